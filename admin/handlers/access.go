@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/jmpsec/osctrl/admin/sessions"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+// Helper to record a download of the admin UI in the data access log, ranged requests resuming it are not recorded
+func (h *HandlersAdmin) recordAccess(r *http.Request, envID uint, user, entityType, entity, detail string, size int64) {
+	if !environments.LogicalDownload(r.Header.Get("Range")) {
+		return
+	}
+	h.Envs.RecordAccess(environments.DataAccess{
+		EnvironmentID: envID,
+		Actor:         user,
+		Via:           environments.AccessViaSession,
+		EntityType:    entityType,
+		Entity:        entity,
+		Detail:        detail,
+		Bytes:         size,
+		IPAddress:     utils.GetIP(r),
+	})
+}
+
+// Helper to get the latest accesses to an entity for the panels of details pages, errors are only logged
+func (h *HandlersAdmin) entityAccesses(envID uint, entityTypes []string, entity string) []environments.DataAccess {
+	entries, err := h.Envs.EntityAccesses(envID, entityTypes, entity)
+	if err != nil {
+		log.Printf("error getting accesses to %s %v", entity, err)
+	}
+	return entries
+}
+
+// Helper to generate the URL of a page of the data access log, keeping the filter
+func accessPageURL(q url.Values, page int) string {
+	values := url.Values{}
+	for k, v := range q {
+		values[k] = v
+	}
+	values.Set("page", strconv.Itoa(page))
+	return "/access-log?" + values.Encode()
+}
+
+// Helper to get the filter of the data access log, with the environment by name or UUID
+func (h *HandlersAdmin) accessFilter(r *http.Request) (environments.AccessFilter, error) {
+	q := r.URL.Query()
+	filter, err := environments.ParseAccessFilter(q, time.Now())
+	if err != nil {
+		return filter, err
+	}
+	if envVar := q.Get("env"); envVar != "" {
+		env, err := h.Envs.GetCtx(r.Context(), envVar)
+		if err != nil {
+			return filter, fmt.Errorf("invalid environment %s", envVar)
+		}
+		filter.EnvironmentIDs = []uint{env.ID}
+	}
+	return filter, nil
+}
+
+// AccessLogGETHandler for GET requests for /access-log to report who downloaded carves, query results and node data
+func (h *HandlersAdmin) AccessLogGETHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), false)
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Check permissions
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.AdminLevel, users.NoEnvironment) {
+		log.Printf("%s has insuficient permissions", ctx[sessions.CtxUser])
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Invalid filters show all the accesses
+	q := r.URL.Query()
+	filter, err := h.accessFilter(r)
+	if err != nil {
+		log.Printf("invalid access filter %v", err)
+		filter = environments.AccessFilter{}.Normalize()
+	}
+	// Get stats for all environments
+	envAll, err := h.Envs.AllCtx(r.Context())
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environments %v", err)
+		return
+	}
+	envNames := make(map[uint]string, len(envAll))
+	for _, e := range envAll {
+		envNames[e.ID] = e.Name
+	}
+	if q.Get("format") == "csv" {
+		h.accessLogExport(w, r, ctx[sessions.CtxUser], filter, envNames)
+		return
+	}
+	entries, total, err := h.Envs.AccessLog(filter)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting data access log: %v", err)
+		return
+	}
+	// Custom functions to handle formatting
+	funcMap := template.FuncMap{
+		"pastFutureTimes":         utils.PastFutureTimes,
+		"bytesReceivedConversion": func(b int64) string { return utils.BytesReceivedConversion(int(b)) },
+		"envName":                 func(id uint) string { return envNames[id] },
+	}
+	// Prepare template
+	tempateFiles := h.NewTemplateFiles(h.TemplatesFolder, "access-log.html").filepaths
+	t, err := template.New("access-log.html").Funcs(funcMap).ParseFiles(tempateFiles...)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting access log template: %v", err)
+		return
+	}
+	// Get stats for all platforms
+	platforms, err := h.Nodes.GetAllPlatforms()
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting platforms: %v", err)
+		return
+	}
+	exportValues := url.Values{}
+	for k, v := range q {
+		exportValues[k] = v
+	}
+	exportValues.Del("page")
+	exportValues.Set("format", "csv")
+	// Prepare template data
+	templateData := AccessLogTemplateData{
+		Title:        "Data access",
+		EntityTypes:  environments.AccessEntities,
+		Filter:       filter,
+		Env:          q.Get("env"),
+		EntityType:   q.Get("entity_type"),
+		From:         q.Get("from"),
+		To:           q.Get("to"),
+		Entries:      entries,
+		Total:        total,
+		ExportURL:    h.URLPrefix + "/access-log?" + exportValues.Encode(),
+		Metadata:     h.TemplateMetadata(ctx, h.ServiceVersion),
+		Environments: h.allowedEnvironments(ctx[sessions.CtxUser], envAll),
+		Platforms:    platforms,
+	}
+	if filter.Page > 1 {
+		templateData.PrevURL = h.URLPrefix + accessPageURL(q, filter.Page-1)
+	}
+	if int64(filter.Page*filter.Limit) < total {
+		templateData.NextURL = h.URLPrefix + accessPageURL(q, filter.Page+1)
+	}
+	if err := t.Execute(w, templateData); err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("template error %v", err)
+		return
+	}
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Access log template served")
+	}
+	h.Inc(metricAdminOK)
+}
+
+// Helper to export the data access log as CSV, the export is also recorded as an access
+func (h *HandlersAdmin) accessLogExport(w http.ResponseWriter, r *http.Request, user string, filter environments.AccessFilter, envNames map[uint]string) {
+	filter.Page = 1
+	filter.Limit = environments.MaxAccessLimit
+	entries, _, err := h.Envs.AccessLog(filter)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting data access log: %v", err)
+		return
+	}
+	filename := fmt.Sprintf("access-log-%s.csv", time.Now().UTC().Format("20060102-150405"))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Header().Set(utils.ContentType, "text/csv")
+	w.WriteHeader(http.StatusOK)
+	counter := &environments.AccessCounter{ResponseWriter: w}
+	cw := csv.NewWriter(counter)
+	_ = cw.Write([]string{"timestamp", "environment", "actor", "via", "entity_type", "entity", "detail", "bytes", "ip_address"})
+	for _, a := range entries {
+		_ = cw.Write([]string{
+			a.CreatedAt.UTC().Format(time.RFC3339),
+			envNames[a.EnvironmentID],
+			a.Actor,
+			a.Via,
+			a.EntityType,
+			a.Entity,
+			a.Detail,
+			strconv.FormatInt(a.Bytes, 10),
+			a.IPAddress,
+		})
+	}
+	cw.Flush()
+	h.recordAccess(r, 0, user, environments.AccessReport, filename, r.URL.RawQuery, counter.Bytes)
+	h.Inc(metricAdminOK)
+}
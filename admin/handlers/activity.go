@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/admin/sessions"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+// Helper to generate the URL of a page of activity, keeping the filter
+func activityPageURL(envUUID string, q url.Values, page int) string {
+	values := url.Values{}
+	for k, v := range q {
+		values[k] = v
+	}
+	values.Set("page", strconv.Itoa(page))
+	return "/activity/" + envUUID + "?" + values.Encode()
+}
+
+// ActivityGETHandler for GET requests for /activity
+func (h *HandlersAdmin) ActivityGETHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), false)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["environment"]
+	if !ok {
+		h.Inc(metricAdminErr)
+		log.Println("error getting environment")
+		return
+	}
+	// Get environment
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environment: %v", err)
+		return
+	}
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Check permissions
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.UserLevel, env.UUID) {
+		log.Printf("%s has insuficient permissions", ctx[sessions.CtxUser])
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Invalid filters show all the activity
+	q := r.URL.Query()
+	filter, err := environments.ParseActivityFilter(q, time.Now())
+	if err != nil {
+		log.Printf("invalid activity filter %v", err)
+		filter = environments.ActivityFilter{}.Normalize()
+	}
+	entries, total, err := h.Envs.Activity(env.ID, filter)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting activity: %v", err)
+		return
+	}
+	// Custom functions to handle formatting
+	funcMap := template.FuncMap{
+		"pastFutureTimes": utils.PastFutureTimes,
+	}
+	// Prepare template
+	tempateFiles := h.NewTemplateFiles(h.TemplatesFolder, "activity.html").filepaths
+	t, err := template.New("activity.html").Funcs(funcMap).ParseFiles(tempateFiles...)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting activity template: %v", err)
+		return
+	}
+	// Get stats for all environments
+	envAll, err := h.Envs.AllCtx(r.Context())
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environments %v", err)
+		return
+	}
+	// Get stats for all platforms
+	platforms, err := h.Nodes.GetAllPlatforms()
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting platforms: %v", err)
+		return
+	}
+	// Prepare template data
+	templateData := ActivityTemplateData{
+		Title:        env.Name + " Activity",
+		EnvName:      env.Name,
+		EnvUUID:      env.UUID,
+		Kinds:        environments.ActivityKinds,
+		Filter:       filter,
+		From:         q.Get("from"),
+		Kind:         q.Get("kind"),
+		Entries:      entries,
+		Total:        total,
+		Metadata:     h.EnvTemplateMetadata(ctx, h.ServiceVersion, env.UUID),
+		LeftMetadata: AsideLeftMetadata{EnvUUID: env.UUID},
+		Environments: h.allowedEnvironments(ctx[sessions.CtxUser], envAll),
+		Platforms:    platforms,
+	}
+	if filter.Page > 1 {
+		templateData.PrevURL = h.URLPrefix + activityPageURL(env.UUID, q, filter.Page-1)
+	}
+	if int64(filter.Page*filter.Limit) < total {
+		templateData.NextURL = h.URLPrefix + activityPageURL(env.UUID, q, filter.Page+1)
+	}
+	if err := t.Execute(w, templateData); err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("template error %v", err)
+		return
+	}
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Activity template served")
+	}
+	h.Inc(metricAdminOK)
+}
+
+// ActivityDiffGETHandler for GET requests for /activity/{environment}/{id}
+func (h *HandlersAdmin) ActivityDiffGETHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), false)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["environment"]
+	if !ok {
+		h.Inc(metricAdminErr)
+		log.Println("error getting environment")
+		return
+	}
+	// Extract id
+	id, err := strconv.ParseUint(vars["id"], 10, 64)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting activity id: %v", err)
+		return
+	}
+	// Get environment
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environment: %v", err)
+		return
+	}
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Check permissions
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.UserLevel, env.UUID) {
+		log.Printf("%s has insuficient permissions", ctx[sessions.CtxUser])
+		h.Inc(metricAdminErr)
+		return
+	}
+	entry, err := h.Envs.GetActivity(env.ID, uint(id))
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting activity: %v", err)
+		return
+	}
+	// Custom functions to handle formatting
+	funcMap := template.FuncMap{
+		"pastFutureTimes": utils.PastFutureTimes,
+	}
+	// Prepare template
+	tempateFiles := h.NewTemplateFiles(h.TemplatesFolder, "activity-diff.html").filepaths
+	t, err := template.New("activity-diff.html").Funcs(funcMap).ParseFiles(tempateFiles...)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting activity diff template: %v", err)
+		return
+	}
+	// Get stats for all environments
+	envAll, err := h.Envs.AllCtx(r.Context())
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environments %v", err)
+		return
+	}
+	// Get stats for all platforms
+	platforms, err := h.Nodes.GetAllPlatforms()
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting platforms: %v", err)
+		return
+	}
+	// Prepare template data
+	templateData := ActivityDiffTemplateData{
+		Title:        env.Name + " Activity",
+		EnvName:      env.Name,
+		EnvUUID:      env.UUID,
+		Entry:        entry,
+		Diff:         environments.DiffLines(entry.Before, entry.After),
+		Metadata:     h.EnvTemplateMetadata(ctx, h.ServiceVersion, env.UUID),
+		LeftMetadata: AsideLeftMetadata{EnvUUID: env.UUID},
+		Environments: h.allowedEnvironments(ctx[sessions.CtxUser], envAll),
+		Platforms:    platforms,
+	}
+	if err := t.Execute(w, templateData); err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("template error %v", err)
+		return
+	}
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Activity diff template served")
+	}
+	h.Inc(metricAdminOK)
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/admin/sessions"
+	"github.com/jmpsec/osctrl/carves"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+// CarveLimitsPOSTHandler for POST requests to change the carve limits of an environment
+func (h *HandlersAdmin) CarveLimitsPOSTHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), true)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		h.Inc(metricAdminErr)
+		log.Println("error getting environment")
+		return
+	}
+	// Get environment
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environment: %v", err)
+		return
+	}
+	var l CarveLimitsRequest
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Check permissions
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.AdminLevel, env.UUID) {
+		adminErrorResponse(w, fmt.Sprintf("%s has insuficient permissions", ctx[sessions.CtxUser]), http.StatusForbidden, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Parse request JSON body
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Decoding POST body")
+	}
+	if err := json.NewDecoder(r.Body).Decode(&l); err != nil {
+		adminErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Check CSRF Token
+	if !sessions.CheckCSRFToken(ctx[sessions.CtxCSRF], l.CSRFToken) {
+		adminErrorResponse(w, "invalid CSRF token", http.StatusInternalServerError, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	maxBytes, err := environments.ParseStorageSize(l.MaxSize)
+	if err != nil {
+		adminErrorResponse(w, "invalid maximum carve size", http.StatusBadRequest, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	dailyBytes, err := environments.ParseStorageSize(l.DailySize)
+	if err != nil {
+		adminErrorResponse(w, "invalid daily budget", http.StatusBadRequest, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	before, err := h.Carves.GetLimits(env.ID)
+	if err != nil {
+		adminErrorResponse(w, "error getting carve limits", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	limits := carves.CarveLimits{
+		MaxCarveBytes:   maxBytes,
+		MaxNodeSessions: l.MaxNodeSessions,
+		MaxEnvSessions:  l.MaxEnvSessions,
+		DailyBytes:      dailyBytes,
+		DeniedPaths:     l.DeniedPaths,
+	}
+	limits, err = h.Carves.SetLimits(env.ID, limits, ctx[sessions.CtxUser])
+	if err != nil {
+		adminErrorResponse(w, "error setting carve limits", http.StatusBadRequest, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	h.recordActivity(env, environments.ActivityCarves, ctx[sessions.CtxUser], "carve limits updated", carves.LimitsSummary(before), carves.LimitsSummary(limits))
+	adminOKResponse(w, "carve limits saved successfully")
+	// Serialize and send response
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Carve limits response sent")
+	}
+	h.Inc(metricAdminOK)
+}
+
+// Helper to check the path of a new carve against the denied paths of the environment. Admins can carve a denied
+// path with a justification, returning the override to keep once the carve exists. Rejections are audited.
+func (h *HandlersAdmin) carvePathAllowed(env environments.TLSEnvironment, user, path, justification string) (*carves.CarvePathOverride, string, error) {
+	limits, err := h.Carves.GetLimits(env.ID)
+	if err != nil {
+		return nil, "", err
+	}
+	pattern, denied := limits.PathDenied(path)
+	if !denied {
+		return nil, "", nil
+	}
+	if justification != "" && h.Users.CheckPermissions(user, users.AdminLevel, env.UUID) {
+		return &carves.CarvePathOverride{EnvironmentID: env.ID, Path: path, Justification: justification, User: user}, pattern, nil
+	}
+	reason := fmt.Errorf("path %s is denied by %s, admins can carve it with a justification", path, pattern)
+	rejection := carves.CarveRejection{
+		EnvironmentID: env.ID,
+		Limit:         carves.LimitPath,
+		Path:          path,
+		Requester:     user,
+		Detail:        reason.Error(),
+	}
+	if err := h.Carves.RecordRejection(rejection); err != nil {
+		log.Printf("error recording carve rejection %v", err)
+	}
+	return nil, pattern, reason
+}
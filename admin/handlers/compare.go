@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmpsec/osctrl/admin/sessions"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+// Helper to prepare the snapshots of nodes of one environment, with the configuration served to each of them
+func (h *HandlersAdmin) nodeSnapshots(ctx context.Context, env environments.TLSEnvironment, nds []nodes.OsqueryNode) ([]nodes.NodeSnapshot, error) {
+	profiles, err := h.Envs.ProfilesCtx(ctx, env.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting profiles - %v", err)
+	}
+	channels, err := h.Envs.WindowsEventsCtx(ctx, env.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting windows event channels - %v", err)
+	}
+	denylist, err := h.Envs.ScheduleDenylistCtx(ctx, env.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting denylist - %v", err)
+	}
+	overrides, err := h.Envs.EnvScheduleOverrides(env.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting schedule overrides - %v", err)
+	}
+	nodeOverrides := make(map[string][]environments.ScheduleOverride)
+	for _, o := range overrides {
+		nodeOverrides[o.UUID] = append(nodeOverrides[o.UUID], o)
+	}
+	ids := make([]uint, 0, len(nds))
+	for _, n := range nds {
+		ids = append(ids, n.ID)
+	}
+	nodeTags, err := h.Tags.TagNamesOfNodes(ids)
+	if err != nil {
+		return nil, fmt.Errorf("error getting tags - %v", err)
+	}
+	attrs, err := h.Nodes.AttributesOfNodes(nds)
+	if err != nil {
+		return nil, fmt.Errorf("error getting attributes - %v", err)
+	}
+	now := time.Now()
+	snapshots := make([]nodes.NodeSnapshot, 0, len(nds))
+	for _, n := range nds {
+		pNode := environments.ProfileNode{Platform: n.Platform, HardwareModel: nodes.HardwareModel(n), Tags: nodeTags[n.ID]}
+		conf, profile, err := environments.NodeConfiguration(env, profiles, channels, denylist, nodeOverrides[n.UUID], pNode)
+		if err != nil {
+			return nil, fmt.Errorf("error rendering configuration of %s - %v", n.UUID, err)
+		}
+		snapshot, err := nodes.NewNodeSnapshot(n, h.Settings.InactiveHours(), profile, conf, nodeTags[n.ID], attrs[n.ID], now)
+		if err != nil {
+			return nil, fmt.Errorf("error preparing %s - %v", n.UUID, err)
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots, nil
+}
+
+// Helper to get the snapshot of a node to compare from environment/uuid, or the baseline of an environment
+// from environment/baseline, checking the user can see the environment
+func (h *HandlersAdmin) compareTarget(ctx context.Context, user, value string) (nodes.NodeSnapshot, string, error) {
+	envVar, uuid, err := nodes.ParseCompareTarget(value)
+	if err != nil {
+		return nodes.NodeSnapshot{}, "", err
+	}
+	env, err := h.Envs.GetCtx(ctx, envVar)
+	if err != nil {
+		return nodes.NodeSnapshot{}, "", fmt.Errorf("environment %s not found", envVar)
+	}
+	if !h.Users.CheckPermissions(user, users.UserLevel, env.UUID) {
+		return nodes.NodeSnapshot{}, "", fmt.Errorf("%s has insuficient permissions for %s", user, env.Name)
+	}
+	var nds []nodes.OsqueryNode
+	baseline := uuid == nodes.CompareBaseline
+	if baseline {
+		if nds, err = h.Nodes.GetByEnvCtx(ctx, env.Name, "active", h.Settings.InactiveHours()); err != nil {
+			return nodes.NodeSnapshot{}, "", err
+		}
+		if len(nds) > nodes.BaselineSample {
+			nds = nds[:nodes.BaselineSample]
+		}
+	} else {
+		node, err := h.Nodes.GetByUUIDEnv(uuid, env.ID)
+		if err != nil {
+			return nodes.NodeSnapshot{}, "", fmt.Errorf("node %s not found", value)
+		}
+		nds = []nodes.OsqueryNode{node}
+	}
+	snapshots, err := h.nodeSnapshots(ctx, env, nds)
+	if err != nil {
+		return nodes.NodeSnapshot{}, "", err
+	}
+	if baseline {
+		return nodes.BaselineSnapshot(env.Name, snapshots), env.UUID, nil
+	}
+	return snapshots[0], env.UUID, nil
+}
+
+// Helper to prepare the sections of a comparison with the pages before and after the current one
+func compareSectionViews(comparison nodes.NodeComparison) []CompareSectionView {
+	views := make([]CompareSectionView, 0, len(comparison.Sections))
+	for _, s := range comparison.Sections {
+		view := CompareSectionView{SectionDiff: s, Pages: (s.Differences + s.PerPage - 1) / s.PerPage}
+		if s.Page > 1 {
+			view.Prev = s.Page - 1
+		}
+		if s.Page < view.Pages {
+			view.Next = s.Page + 1
+		}
+		views = append(views, view)
+	}
+	return views
+}
+
+// NodeCompareGETHandler for GET requests for /nodes/compare?a=env/uuid&b=env/uuid to show the differences of two nodes
+func (h *HandlersAdmin) NodeCompareGETHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), false)
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	user := ctx[sessions.CtxUser]
+	query := r.URL.Query()
+	templateData := NodeCompareTemplateData{
+		Title:   "Compare nodes",
+		A:       query.Get("a"),
+		B:       query.Get("b"),
+		Section: query.Get("section"),
+	}
+	if templateData.Section != "" && !nodes.ValidCompareSection(templateData.Section) {
+		templateData.Error = fmt.Sprintf("invalid section %s", templateData.Section)
+	}
+	page, _ := strconv.Atoi(query.Get("page"))
+	if templateData.A != "" && templateData.B != "" && templateData.Error == "" {
+		a, envUUID, err := h.compareTarget(r.Context(), user, templateData.A)
+		if err != nil {
+			templateData.Error = err.Error()
+		}
+		b, _, errB := h.compareTarget(r.Context(), user, templateData.B)
+		if errB != nil && err == nil {
+			templateData.Error = errB.Error()
+		}
+		if templateData.Error == "" {
+			var sections []string
+			if templateData.Section != "" {
+				sections = []string{templateData.Section}
+			}
+			comparison := nodes.CompareSnapshots(a, b, sections, page, nodes.DefaultComparePerPage)
+			templateData.NodeA = comparison.A
+			templateData.NodeB = comparison.B
+			templateData.Sections = compareSectionViews(comparison)
+			templateData.LeftMetadata.EnvUUID = envUUID
+		}
+	}
+	// Prepare template
+	tempateFiles := h.NewTemplateFiles(h.TemplatesFolder, "node-compare.html").filepaths
+	t, err := template.ParseFiles(tempateFiles...)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting compare template: %v", err)
+		return
+	}
+	// Get all environments
+	envAll, err := h.Envs.AllCtx(r.Context())
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environments %v", err)
+		return
+	}
+	// Get all platforms
+	platforms, err := h.Nodes.GetAllPlatforms()
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting platforms: %v", err)
+		return
+	}
+	templateData.Metadata = h.TemplateMetadata(ctx, h.ServiceVersion)
+	templateData.Environments = h.allowedEnvironments(user, envAll)
+	templateData.Platforms = platforms
+	if err := t.Execute(w, templateData); err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("template error %v", err)
+		return
+	}
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Compare template served")
+	}
+	h.Inc(metricAdminOK)
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+
+	"github.com/jmpsec/osctrl/admin/sessions"
+	"github.com/jmpsec/osctrl/logging"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+// Number of latest dead letters displayed
+const deadLettersDisplayed = 100
+
+// DeadLettersGETHandler for GET requests for /logs/dlq
+func (h *HandlersAdmin) DeadLettersGETHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), false)
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Check permissions
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.AdminLevel, users.NoEnvironment) {
+		log.Printf("%s has insuficient permissions", ctx[sessions.CtxUser])
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Custom functions to handle formatting
+	funcMap := template.FuncMap{
+		"pastFutureTimes": utils.PastFutureTimes,
+	}
+	// Prepare template
+	tempateFiles := h.NewTemplateFiles(h.TemplatesFolder, "deadletters.html").filepaths
+	t, err := template.New("deadletters.html").Funcs(funcMap).ParseFiles(tempateFiles...)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting dead letters template: %v", err)
+		return
+	}
+	// Get counts by reason and the latest dead letters
+	report, err := h.DeadLetters.Report(logging.DeadLetterFilter{Limit: deadLettersDisplayed})
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting dead letters: %v", err)
+		return
+	}
+	// Prepare template data
+	templateData := DeadLettersTemplateData{
+		Title:    "Dead letters",
+		Metadata: h.TemplateMetadata(ctx, h.ServiceVersion),
+		Counts:   report.Counts,
+		Entries:  report.Entries,
+		Targets:  []string{settings.LoggingDB},
+	}
+	if err := t.Execute(w, templateData); err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("template error %v", err)
+		return
+	}
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Dead letters template served")
+	}
+	h.Inc(metricAdminOK)
+}
+
+// DeadLettersPOSTHandler for POST requests for /logs/dlq
+func (h *HandlersAdmin) DeadLettersPOSTHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), true)
+	var dr DeadLettersRequest
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Check permissions
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.AdminLevel, users.NoEnvironment) {
+		adminErrorResponse(w, fmt.Sprintf("%s has insuficient permissions", ctx[sessions.CtxUser]), http.StatusForbidden, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Parse request JSON body
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Decoding POST body")
+	}
+	if err := json.NewDecoder(r.Body).Decode(&dr); err != nil {
+		adminErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Check CSRF Token
+	if !sessions.CheckCSRFToken(ctx[sessions.CtxCSRF], dr.CSRFToken) {
+		adminErrorResponse(w, "invalid CSRF token", http.StatusInternalServerError, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Dead letters are selected by id or by backend and reason, never all of them by accident
+	filter := logging.DeadLetterFilter{
+		IDs:     dr.IDs,
+		Backend: dr.Backend,
+		Reason:  dr.Reason,
+	}
+	if filter.Empty() {
+		adminErrorResponse(w, "no dead letters selected", http.StatusBadRequest, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	switch dr.Action {
+	case "replay":
+		replayed, err := h.DeadLetters.Replay(filter, dr.Target)
+		if err != nil {
+			adminErrorResponse(w, "error replaying dead letters", http.StatusInternalServerError, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		log.Printf("%d dead letters queued for replay by %s", replayed, ctx[sessions.CtxUser])
+		adminOKResponse(w, fmt.Sprintf("%d dead letters queued for replay", replayed))
+	case "purge":
+		purged, err := h.DeadLetters.Purge(filter)
+		if err != nil {
+			adminErrorResponse(w, "error purging dead letters", http.StatusInternalServerError, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		log.Printf("%d dead letters purged by %s", purged, ctx[sessions.CtxUser])
+		adminOKResponse(w, fmt.Sprintf("%d dead letters purged", purged))
+	default:
+		adminErrorResponse(w, "invalid action", http.StatusBadRequest, fmt.Errorf("invalid action %s", dr.Action))
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Serialize and send response
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Dead letters response sent")
+	}
+	h.Inc(metricAdminOK)
+}
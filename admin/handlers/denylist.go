@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/admin/sessions"
+	"github.com/jmpsec/osctrl/cache"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+// DenylistPOSTHandler for POST requests to add and lift entries of the scheduled query denylist of an environment
+func (h *HandlersAdmin) DenylistPOSTHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), true)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["environment"]
+	if !ok {
+		h.Inc(metricAdminErr)
+		log.Println("error getting environment")
+		return
+	}
+	// Get environment
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environment: %v", err)
+		return
+	}
+	var d DenylistRequest
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Check permissions
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.AdminLevel, env.UUID) {
+		adminErrorResponse(w, fmt.Sprintf("%s has insuficient permissions", ctx[sessions.CtxUser]), http.StatusForbidden, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Parse request JSON body
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Decoding POST body")
+	}
+	if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+		adminErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Check CSRF Token
+	if !sessions.CheckCSRFToken(ctx[sessions.CtxCSRF], d.CSRFToken) {
+		adminErrorResponse(w, "invalid CSRF token", http.StatusInternalServerError, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	switch d.Action {
+	case "add":
+		entry := environments.ScheduleDeny{
+			Pack:     d.Pack,
+			Query:    d.Query,
+			Platform: d.Platform,
+			Tag:      d.Tag,
+			Reason:   d.Reason,
+			Creator:  ctx[sessions.CtxUser],
+		}
+		if d.Hours > 0 {
+			entry.ExpiresAt = time.Now().Add(time.Duration(d.Hours) * time.Hour)
+		}
+		saved, err := h.Envs.AddScheduleDeny(env.ID, entry)
+		if err != nil {
+			adminErrorResponse(w, "error adding to denylist", http.StatusBadRequest, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		h.recordActivity(env, environments.ActivityDenylist, ctx[sessions.CtxUser], "added to denylist "+saved.Summary(), "", "")
+		h.publishEvent(cache.EventEnvironment, env.UUID, environments.ActivityDenylist)
+		adminOKResponse(w, "denylist entry added successfully")
+	case "lift":
+		entry, err := h.Envs.GetScheduleDeny(env.ID, d.ID)
+		if err != nil {
+			adminErrorResponse(w, "denylist entry not found", http.StatusNotFound, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		if err := h.Envs.DeleteScheduleDeny(env.ID, entry.ID); err != nil {
+			adminErrorResponse(w, "error lifting denylist entry", http.StatusInternalServerError, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		h.recordActivity(env, environments.ActivityDenylist, ctx[sessions.CtxUser], "lifted from denylist "+entry.Target(), "", "")
+		h.publishEvent(cache.EventEnvironment, env.UUID, environments.ActivityDenylist)
+		adminOKResponse(w, "denylist entry lifted successfully")
+	default:
+		adminErrorResponse(w, "invalid action", http.StatusBadRequest, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Serialize and send response
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Denylist response sent")
+	}
+	h.Inc(metricAdminOK)
+}
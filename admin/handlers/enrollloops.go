@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/admin/sessions"
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+// EnrollLoopsGETHandler for GET requests for /enroll-loops/{environment}
+func (h *HandlersAdmin) EnrollLoopsGETHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), false)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["environment"]
+	if !ok {
+		h.Inc(metricAdminErr)
+		log.Println("error getting environment")
+		return
+	}
+	// Get environment
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environment: %v", err)
+		return
+	}
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Check permissions
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.UserLevel, env.UUID) {
+		log.Printf("%s has insuficient permissions", ctx[sessions.CtxUser])
+		h.Inc(metricAdminErr)
+		return
+	}
+	loops, err := h.Nodes.GetEnrollLoops(env.ID)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting enroll loops: %v", err)
+		return
+	}
+	// Custom functions to handle formatting
+	funcMap := template.FuncMap{
+		"pastFutureTimes": utils.PastFutureTimes,
+	}
+	// Prepare template
+	tempateFiles := h.NewTemplateFiles(h.TemplatesFolder, "enroll-loops.html").filepaths
+	t, err := template.New("enroll-loops.html").Funcs(funcMap).ParseFiles(tempateFiles...)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting enroll loops template: %v", err)
+		return
+	}
+	// Get stats for all environments
+	envAll, err := h.Envs.AllCtx(r.Context())
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environments %v", err)
+		return
+	}
+	// Get stats for all platforms
+	platforms, err := h.Nodes.GetAllPlatforms()
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting platforms: %v", err)
+		return
+	}
+	// Prepare template data
+	templateData := EnrollLoopsTemplateData{
+		Title:        env.Name + " Enroll Loops",
+		EnvName:      env.Name,
+		EnvUUID:      env.UUID,
+		Config:       nodes.NewEnrollLoopConfig(env.EnrollLoopLimit, env.EnrollLoopQuiet, env.EnrollBackoff),
+		Loops:        loops,
+		Now:          time.Now(),
+		Metadata:     h.EnvTemplateMetadata(ctx, h.ServiceVersion, env.UUID),
+		LeftMetadata: AsideLeftMetadata{EnvUUID: env.UUID},
+		Environments: h.allowedEnvironments(ctx[sessions.CtxUser], envAll),
+		Platforms:    platforms,
+	}
+	if err := t.Execute(w, templateData); err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("template error %v", err)
+		return
+	}
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Enroll loops template served")
+	}
+	h.Inc(metricAdminOK)
+}
@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/admin/sessions"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/locales"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+// EnvTemplatesGETHandler for GET requests for /environments/templates
+func (h *HandlersAdmin) EnvTemplatesGETHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), false)
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Check permissions
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.AdminLevel, users.NoEnvironment) {
+		log.Printf("%s has insuficient permissions", ctx[sessions.CtxUser])
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Prepare template
+	tempateFiles := h.NewTemplateFiles(h.TemplatesFolder, "env-templates.html").filepaths
+	t, err := template.ParseFiles(tempateFiles...)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environment templates template: %v", err)
+		return
+	}
+	// Get stats for all environments
+	envAll, err := h.Envs.All()
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environments %v", err)
+		return
+	}
+	// Get stats for all platforms
+	platforms, err := h.Nodes.GetAllPlatforms()
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting platforms: %v", err)
+		return
+	}
+	envTemplates, err := h.Envs.Templates()
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environment templates %v", err)
+		return
+	}
+	// Count the environments created from each template
+	derived := make(map[uint]int)
+	for _, e := range envAll {
+		if e.TemplateID != 0 {
+			derived[e.TemplateID]++
+		}
+	}
+	// Prepare template data
+	templateData := EnvTemplatesTemplateData{
+		Title:        "Environment templates",
+		Templates:    envTemplates,
+		Derived:      derived,
+		Metadata:     h.TemplateMetadata(ctx, h.ServiceVersion),
+		Environments: h.allowedEnvironments(ctx[sessions.CtxUser], envAll),
+		Platforms:    platforms,
+	}
+	if err := t.Execute(w, templateData); err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("template error %v", err)
+		return
+	}
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Environment templates template served")
+	}
+	h.Inc(metricAdminOK)
+}
+
+// EnvTemplateGETHandler for GET requests for /environments/templates/{name}
+func (h *HandlersAdmin) EnvTemplateGETHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), false)
+	vars := mux.Vars(r)
+	// Extract template name
+	nameVar, ok := vars["name"]
+	if !ok {
+		h.Inc(metricAdminErr)
+		log.Println("error getting template name")
+		return
+	}
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Check permissions
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.AdminLevel, users.NoEnvironment) {
+		log.Printf("%s has insuficient permissions", ctx[sessions.CtxUser])
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Get the template with what would change in each environment created from it
+	envTemplate, propagation, err := h.Envs.PropagateDiffs(nameVar)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environment template: %v", err)
+		return
+	}
+	// Prepare template
+	tempateFiles := h.NewTemplateFiles(h.TemplatesFolder, "env-template.html").filepaths
+	t, err := template.ParseFiles(tempateFiles...)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environment template template: %v", err)
+		return
+	}
+	// Get stats for all environments
+	envAll, err := h.Envs.All()
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environments %v", err)
+		return
+	}
+	// Get stats for all platforms
+	platforms, err := h.Nodes.GetAllPlatforms()
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting platforms: %v", err)
+		return
+	}
+	// Prepare template data
+	templateData := EnvTemplateTemplateData{
+		Title:        "Environment template " + envTemplate.Name,
+		Template:     envTemplate,
+		Propagation:  propagation,
+		Locales:      locales.Names(),
+		Metadata:     h.TemplateMetadata(ctx, h.ServiceVersion),
+		Environments: h.allowedEnvironments(ctx[sessions.CtxUser], envAll),
+		Platforms:    platforms,
+	}
+	if err := t.Execute(w, templateData); err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("template error %v", err)
+		return
+	}
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Environment template template served")
+	}
+	h.Inc(metricAdminOK)
+}
+
+// EnvTemplatesPOSTHandler for POST requests for /environments/templates
+func (h *HandlersAdmin) EnvTemplatesPOSTHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), true)
+	var e EnvTemplateRequest
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Check permissions
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.AdminLevel, users.NoEnvironment) {
+		adminErrorResponse(w, fmt.Sprintf("%s has insuficient permissions", ctx[sessions.CtxUser]), http.StatusForbidden, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Parse request JSON body
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Decoding POST body")
+	}
+	if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+		adminErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Check CSRF Token
+	if !sessions.CheckCSRFToken(ctx[sessions.CtxCSRF], e.CSRFToken) {
+		adminErrorResponse(w, "invalid CSRF token", http.StatusInternalServerError, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	switch e.Action {
+	case "create":
+		// New templates start from the configuration of an existing environment
+		env, err := h.Envs.Get(e.Environment)
+		if err != nil {
+			adminErrorResponse(w, "error getting environment", http.StatusBadRequest, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		newTemplate := environments.EnvTemplate{
+			Name:           e.Name,
+			Description:    e.Description,
+			TemplateConfig: h.Envs.TemplateOf(env),
+		}
+		if _, err := h.Envs.CreateTemplate(newTemplate, ctx[sessions.CtxUser]); err != nil {
+			adminErrorResponse(w, "error creating template", http.StatusBadRequest, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		adminOKResponse(w, "template created successfully")
+	case "update":
+		updated, err := h.Envs.UpdateTemplate(e.Name, e.Description, e.Config, ctx[sessions.CtxUser], e.Note)
+		if err != nil {
+			adminErrorResponse(w, "error updating template", http.StatusBadRequest, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		adminOKResponse(w, fmt.Sprintf("template saved as version %d", updated.Version))
+	case "delete":
+		if err := h.Envs.DeleteTemplate(e.Name); err != nil {
+			adminErrorResponse(w, "error deleting template", http.StatusBadRequest, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		adminOKResponse(w, "template deleted successfully")
+	case "propagate":
+		if len(e.Environments) == 0 {
+			adminErrorResponse(w, "no environments selected", http.StatusBadRequest, nil)
+			h.Inc(metricAdminErr)
+			return
+		}
+		updated, err := h.Envs.PropagateTemplate(e.Name, e.Environments, ctx[sessions.CtxUser])
+		if err != nil {
+			adminErrorResponse(w, "error propagating template", http.StatusBadRequest, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		adminOKResponse(w, fmt.Sprintf("template propagated to %d environments", len(updated)))
+	default:
+		adminErrorResponse(w, "invalid action", http.StatusBadRequest, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Serialize and send response
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Environment templates response sent")
+	}
+	h.Inc(metricAdminOK)
+}
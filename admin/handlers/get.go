@@ -1,15 +1,18 @@
 package handlers
 
 import (
+	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"path"
 	"strconv"
 
 	"github.com/gorilla/mux"
 	"github.com/jmpsec/osctrl/admin/sessions"
 	"github.com/jmpsec/osctrl/carves"
+	"github.com/jmpsec/osctrl/environments"
 	"github.com/jmpsec/osctrl/settings"
 	"github.com/jmpsec/osctrl/users"
 	"github.com/jmpsec/osctrl/utils"
@@ -30,6 +33,14 @@ func (h *HandlersAdmin) HealthHandler(w http.ResponseWriter, r *http.Request) {
 	h.Inc(metricHealthOK)
 }
 
+// VersionHandler for version and build requests, without configuration details
+func (h *HandlersAdmin) VersionHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricHealthReq)
+	// Send response
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, h.BuildInfo)
+	h.Inc(metricHealthOK)
+}
+
 // ErrorHandler for error requests
 func (h *HandlersAdmin) ErrorHandler(w http.ResponseWriter, r *http.Request) {
 	// Send response
@@ -50,9 +61,9 @@ func (h *HandlersAdmin) RootHandler(w http.ResponseWriter, r *http.Request) {
 	// Redirect to table for active nodes in default environment
 	defaultEnvironment := h.Settings.DefaultEnv(settings.ServiceAdmin)
 	if h.Envs.Exists(defaultEnvironment) {
-		http.Redirect(w, r, "/environment/"+defaultEnvironment+"/active", http.StatusFound)
+		http.Redirect(w, r, h.URLPrefix+"/environment/"+defaultEnvironment+"/active", http.StatusFound)
 	} else {
-		http.Redirect(w, r, "/environments", http.StatusFound)
+		http.Redirect(w, r, h.URLPrefix+"/environments", http.StatusFound)
 	}
 }
 
@@ -103,7 +114,7 @@ func (h *HandlersAdmin) CarvesDownloadHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 	// Get environment
-	env, err := h.Envs.Get(envVar)
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
 	if err != nil {
 		log.Printf("error getting environment %s - %v", envVar, err)
 		h.Inc(metricAdminErr)
@@ -124,11 +135,29 @@ func (h *HandlersAdmin) CarvesDownloadHandler(w http.ResponseWriter, r *http.Req
 	}
 	// Check if carve is archived already
 	carve, err := h.Carves.GetBySession(carveSession)
-	if !ok {
+	if err != nil {
 		h.Inc(metricAdminErr)
-		log.Println("error getting carve")
+		log.Printf("error getting carve %v", err)
 		return
 	}
+	// Carves of restricted queries are only downloaded by who can see them
+	if !h.canViewName(r.Context(), carve.QueryName, env.ID, ctx[sessions.CtxUser]) {
+		adminErrorResponse(w, "carve is restricted", http.StatusForbidden, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Enforce the scan policy of the environment, only administrators can override it
+	switch carves.DownloadDecision(env.CarveScanPolicy, carve.ScanVerdict) {
+	case carves.DownloadBlock:
+		if r.URL.Query().Get("override") != "true" || !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.AdminLevel, env.UUID) {
+			adminErrorResponse(w, "download blocked by scan policy", http.StatusForbidden, fmt.Errorf("carve %s verdict %q", carve.CarveID, carve.ScanVerdict))
+			h.Inc(metricAdminErr)
+			return
+		}
+		h.recordActivity(env, environments.ActivityCarves, ctx[sessions.CtxUser], carves.OverrideSummary(carve), "", "")
+	case carves.DownloadWarn:
+		log.Printf("carve %s is %s and downloaded by %s - %s", carve.CarveID, carve.ScanVerdict, ctx[sessions.CtxUser], carve.ScanDetail)
+	}
 	var archived *carves.CarveResult
 	if !carve.Archived {
 		archived, err = h.Carves.Archive(carveSession, h.CarvesFolder)
@@ -161,6 +190,7 @@ func (h *HandlersAdmin) CarvesDownloadHandler(w http.ResponseWriter, r *http.Req
 			log.Printf("error getting carve link - %v", err)
 			return
 		}
+		h.recordAccess(r, env.ID, ctx[sessions.CtxUser], environments.AccessCarve, carve.QueryName, carveSession, archived.Size)
 		http.Redirect(w, r, downloadURL, http.StatusFound)
 	} else {
 		// Send response
@@ -177,7 +207,109 @@ func (h *HandlersAdmin) CarvesDownloadHandler(w http.ResponseWriter, r *http.Req
 		h.Inc(metricAdminOK)
 		var fileReader io.Reader
 		fileReader, _ = os.Open(archived.File)
-		_, _ = io.Copy(w, fileReader)
+		counter := &environments.AccessCounter{ResponseWriter: w}
+		_, _ = io.Copy(counter, fileReader)
+		h.recordAccess(r, env.ID, ctx[sessions.CtxUser], environments.AccessCarve, carve.QueryName, carveSession, counter.Bytes)
+	}
+	h.Inc(metricAdminOK)
+}
+
+// CarvesFileDownloadHandler for GET requests to extract and download one file of a carve, using its manifest
+func (h *HandlersAdmin) CarvesFileDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), false)
+	vars := mux.Vars(r)
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		log.Println("environment is missing")
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Get environment
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		log.Printf("error getting environment %s - %v", envVar, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Check permissions
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.CarveLevel, env.UUID) {
+		log.Printf("%s has insuficient permissions", ctx[sessions.CtxUser])
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Extract session of the carve
+	carveSession, ok := vars["sessionid"]
+	if !ok {
+		h.Inc(metricAdminErr)
+		log.Println("error getting carve")
+		return
+	}
+	carve, err := h.Carves.GetBySession(carveSession)
+	if err != nil || carve.EnvironmentID != env.ID {
+		adminErrorResponse(w, "carve not found", http.StatusNotFound, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	if !h.canViewName(r.Context(), carve.QueryName, env.ID, ctx[sessions.CtxUser]) {
+		adminErrorResponse(w, "carve is restricted", http.StatusForbidden, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	if carve.ManifestStatus != carves.ManifestReady {
+		adminErrorResponse(w, "carve has no manifest, download the whole carve", http.StatusConflict, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	entries, err := carve.Entries()
+	if err != nil {
+		adminErrorResponse(w, "error getting manifest", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	entry, ok := carves.FindEntry(entries, r.URL.Query().Get("path"))
+	if !ok || entry.Type != carves.EntryFile {
+		adminErrorResponse(w, "file not found in carve", http.StatusNotFound, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Files inside a carve follow the scan policy of the whole carve
+	switch carves.DownloadDecision(env.CarveScanPolicy, carve.ScanVerdict) {
+	case carves.DownloadBlock:
+		if r.URL.Query().Get("override") != "true" || !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.AdminLevel, env.UUID) {
+			adminErrorResponse(w, "download blocked by scan policy", http.StatusForbidden, fmt.Errorf("carve %s verdict %q", carve.CarveID, carve.ScanVerdict))
+			h.Inc(metricAdminErr)
+			return
+		}
+		h.recordActivity(env, environments.ActivityCarves, ctx[sessions.CtxUser], carves.OverrideSummary(carve), "", "")
+	case carves.DownloadWarn:
+		log.Printf("carve %s is %s and downloaded by %s - %s", carve.CarveID, carve.ScanVerdict, ctx[sessions.CtxUser], carve.ScanDetail)
+	}
+	content, err := h.Carves.ContentRange(carve, entry.Offset, entry.Size)
+	if err != nil {
+		adminErrorResponse(w, "error extracting file", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	defer content.Close()
+	// Extracting single files is audited, it is the most sensitive access to carves
+	h.recordActivity(env, environments.ActivityCarves, ctx[sessions.CtxUser], carves.ExtractSummary(carve, entry), "", "")
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Carve file download")
+	}
+	w.Header().Set("Content-Description", "File Carve Download")
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(entry.Path)))
+	w.Header().Set("Content-Length", strconv.FormatInt(entry.Size, 10))
+	w.Header().Set("X-Osctrl-Sha256", entry.SHA256)
+	w.WriteHeader(http.StatusOK)
+	counter := &environments.AccessCounter{ResponseWriter: w}
+	if _, err := io.Copy(counter, content); err != nil {
+		log.Printf("error downloading file of carve %s - %v", carve.CarveID, err)
 	}
+	h.recordAccess(r, env.ID, ctx[sessions.CtxUser], environments.AccessCarveFile, carve.QueryName, entry.Path, counter.Bytes)
 	h.Inc(metricAdminOK)
 }
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/admin/sessions"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+// QueryGroupsGETHandler for GET requests for /query/{env}/groups to show the concurrency groups of queries
+func (h *HandlersAdmin) QueryGroupsGETHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), false)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		h.Inc(metricAdminErr)
+		log.Println("error getting environment")
+		return
+	}
+	// Get environment
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environment: %v", err)
+		return
+	}
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Check permissions
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.QueryLevel, env.UUID) {
+		log.Printf("%s has insuficient permissions", ctx[sessions.CtxUser])
+		h.Inc(metricAdminErr)
+		return
+	}
+	groups, err := h.Queries.GroupsUtilization(r.Context(), env.ID)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting query groups: %v", err)
+		return
+	}
+	// Prepare template
+	tempateFiles := h.NewTemplateFiles(h.TemplatesFolder, "queries-groups.html").filepaths
+	t, err := template.New("queries-groups.html").ParseFiles(tempateFiles...)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting query groups template: %v", err)
+		return
+	}
+	// Get all environments
+	envAll, err := h.Envs.AllCtx(r.Context())
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environments %v", err)
+		return
+	}
+	// Get all platforms
+	platforms, err := h.Nodes.GetAllPlatforms()
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting platforms: %v", err)
+		return
+	}
+	// Prepare template data
+	templateData := QueryGroupsTemplateData{
+		Title:        "Query groups in " + env.Name,
+		EnvName:      env.Name,
+		EnvUUID:      env.UUID,
+		Groups:       groups,
+		Environments: h.allowedEnvironments(ctx[sessions.CtxUser], envAll),
+		Platforms:    platforms,
+		Metadata:     h.EnvTemplateMetadata(ctx, h.ServiceVersion, env.UUID),
+		LeftMetadata: AsideLeftMetadata{EnvUUID: env.UUID},
+	}
+	if err := t.Execute(w, templateData); err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("template error %v", err)
+		return
+	}
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Query groups template served")
+	}
+	h.Inc(metricAdminOK)
+}
+
+// QueryGroupsPOSTHandler for POST requests to set the concurrency limits of a group of queries
+func (h *HandlersAdmin) QueryGroupsPOSTHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), true)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		h.Inc(metricAdminErr)
+		log.Println("error getting environment")
+		return
+	}
+	// Get environment
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environment: %v", err)
+		return
+	}
+	var g QueryGroupRequest
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	user := ctx[sessions.CtxUser]
+	// Check permissions, limits apply to all the queries of the group
+	if !h.Users.CheckPermissions(user, users.AdminLevel, env.UUID) {
+		adminErrorResponse(w, fmt.Sprintf("%s has insuficient permissions", user), http.StatusForbidden, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Parse request JSON body
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Decoding POST body")
+	}
+	if err := json.NewDecoder(r.Body).Decode(&g); err != nil {
+		adminErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Check CSRF Token
+	if !sessions.CheckCSRFToken(ctx[sessions.CtxCSRF], g.CSRFToken) {
+		adminErrorResponse(w, "invalid CSRF token", http.StatusInternalServerError, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	group, err := h.Queries.SetGroupLimits(env.ID, g.Name, g.MaxPerNode, g.MaxTotal, user)
+	if err != nil {
+		adminErrorResponse(w, "error setting group limits", http.StatusBadRequest, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	log.Printf("limits of query group %s set to %d per node and %d total by %s", group.Name, group.MaxPerNode, group.MaxTotal, user)
+	adminOKResponse(w, fmt.Sprintf("limits of group %s set successfully", group.Name))
+	// Serialize and send response
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Query groups response sent")
+	}
+	h.Inc(metricAdminOK)
+}
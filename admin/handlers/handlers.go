@@ -5,13 +5,18 @@ import (
 	"github.com/jmpsec/osctrl/cache"
 	"github.com/jmpsec/osctrl/carves"
 	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/logging"
 	"github.com/jmpsec/osctrl/metrics"
 	"github.com/jmpsec/osctrl/nodes"
 	"github.com/jmpsec/osctrl/queries"
+	"github.com/jmpsec/osctrl/reports"
+	"github.com/jmpsec/osctrl/responses"
 	"github.com/jmpsec/osctrl/settings"
 	"github.com/jmpsec/osctrl/tags"
 	"github.com/jmpsec/osctrl/types"
 	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/version"
+	"github.com/jmpsec/osctrl/webhooks"
 	"gorm.io/gorm"
 )
 
@@ -27,6 +32,8 @@ const (
 	metricTokenReq  = "admin-token-req"
 	metricTokenErr  = "admin-token-err"
 	metricTokenOK   = "admin-token-ok"
+	metricEventPub  = "admin-event-published"
+	metricEventErr  = "admin-event-err"
 )
 
 // Default content
@@ -42,17 +49,27 @@ type HandlersAdmin struct {
 	Nodes           *nodes.NodeManager
 	Queries         *queries.Queries
 	Carves          *carves.Carves
+	Reports         *reports.ReportManager
+	DeadLetters     *logging.DeadLetters
+	Responses       *responses.ResponseManager
+	Webhooks        *webhooks.WebhookManager
 	Settings        *settings.Settings
 	Metrics         *metrics.Metrics
 	RedisCache      *cache.RedisManager
+	Events          *cache.EventBus
 	Sessions        *sessions.SessionManager
+	LoginThrottle   *users.LoginThrottle
 	ServiceVersion  string
+	BuildInfo       version.BuildInfo
 	OsqueryVersion  string
 	TemplatesFolder string
 	StaticLocation  string
 	CarvesFolder    string
 	OsqueryTables   []types.OsqueryTable
 	AdminConfig     *types.JSONConfigurationAdmin
+	URLPrefix       string
+	SetupToken      string
+	StatusSecret    string
 }
 
 type HandlersOption func(*HandlersAdmin)
@@ -105,6 +122,30 @@ func WithCarves(carves *carves.Carves) HandlersOption {
 	}
 }
 
+func WithReports(reports *reports.ReportManager) HandlersOption {
+	return func(h *HandlersAdmin) {
+		h.Reports = reports
+	}
+}
+
+func WithDeadLetters(deadletters *logging.DeadLetters) HandlersOption {
+	return func(h *HandlersAdmin) {
+		h.DeadLetters = deadletters
+	}
+}
+
+func WithResponses(responses *responses.ResponseManager) HandlersOption {
+	return func(h *HandlersAdmin) {
+		h.Responses = responses
+	}
+}
+
+func WithWebhooks(webhooks *webhooks.WebhookManager) HandlersOption {
+	return func(h *HandlersAdmin) {
+		h.Webhooks = webhooks
+	}
+}
+
 func WithCarvesFolder(carves string) HandlersOption {
 	return func(h *HandlersAdmin) {
 		h.CarvesFolder = carves
@@ -123,18 +164,36 @@ func WithCache(rds *cache.RedisManager) HandlersOption {
 	}
 }
 
+func WithEvents(events *cache.EventBus) HandlersOption {
+	return func(h *HandlersAdmin) {
+		h.Events = events
+	}
+}
+
 func WithSessions(sessions *sessions.SessionManager) HandlersOption {
 	return func(h *HandlersAdmin) {
 		h.Sessions = sessions
 	}
 }
 
+func WithLoginThrottle(throttle *users.LoginThrottle) HandlersOption {
+	return func(h *HandlersAdmin) {
+		h.LoginThrottle = throttle
+	}
+}
+
 func WithVersion(version string) HandlersOption {
 	return func(h *HandlersAdmin) {
 		h.ServiceVersion = version
 	}
 }
 
+func WithBuildInfo(info version.BuildInfo) HandlersOption {
+	return func(h *HandlersAdmin) {
+		h.BuildInfo = info
+	}
+}
+
 func WithOsqueryVersion(version string) HandlersOption {
 	return func(h *HandlersAdmin) {
 		h.OsqueryVersion = version
@@ -183,3 +242,21 @@ func (h *HandlersAdmin) Inc(name string) {
 		h.Metrics.Inc(name)
 	}
 }
+
+func WithURLPrefix(prefix string) HandlersOption {
+	return func(h *HandlersAdmin) {
+		h.URLPrefix = prefix
+	}
+}
+
+func WithSetupToken(token string) HandlersOption {
+	return func(h *HandlersAdmin) {
+		h.SetupToken = token
+	}
+}
+
+func WithStatusTokenSecret(secret string) HandlersOption {
+	return func(h *HandlersAdmin) {
+		h.StatusSecret = secret
+	}
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/jmpsec/osctrl/admin/sessions"
+	"github.com/jmpsec/osctrl/cache"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+// NodeIdentityPOSTHandler for POST requests to resolve the identity mismatch of a node, accepting the new identity
+// or forcing the node to enroll again
+func (h *HandlersAdmin) NodeIdentityPOSTHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), true)
+	var i NodeIdentityRequest
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Parse request JSON body
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Decoding POST body")
+	}
+	if err := json.NewDecoder(r.Body).Decode(&i); err != nil {
+		adminErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Check CSRF Token
+	if !sessions.CheckCSRFToken(ctx[sessions.CtxCSRF], i.CSRFToken) {
+		adminErrorResponse(w, "invalid CSRF token", http.StatusInternalServerError, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Get node and environment
+	node, err := h.Nodes.GetByUUID(i.UUID)
+	if err != nil {
+		adminErrorResponse(w, "error getting node", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	env, err := h.Envs.GetCtx(r.Context(), node.Environment)
+	if err != nil {
+		adminErrorResponse(w, "error getting environment", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Check permissions
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.AdminLevel, env.UUID) {
+		adminErrorResponse(w, fmt.Sprintf("%s has insuficient permissions", ctx[sessions.CtxUser]), http.StatusForbidden, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	switch i.Action {
+	case nodes.IdentityAccept:
+		identity, err := h.Nodes.AcceptIdentity(node.UUID, ctx[sessions.CtxUser])
+		if err != nil {
+			adminErrorResponse(w, "error accepting identity", http.StatusBadRequest, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		h.recordActivity(env, environments.ActivityIdentity, ctx[sessions.CtxUser], fmt.Sprintf("new identity of node %s (%s) accepted", node.Hostname, node.UUID), identity.Original, identity.Current)
+		adminOKResponse(w, "identity accepted successfully")
+	case nodes.IdentityReenroll:
+		identity, err := h.Nodes.ReenrollIdentity(node.UUID, ctx[sessions.CtxUser])
+		if err != nil {
+			adminErrorResponse(w, "error forcing re-enroll", http.StatusBadRequest, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		h.invalidateResponses(cache.ResponseGroupNodes)
+		h.recordActivity(env, environments.ActivityIdentity, ctx[sessions.CtxUser], fmt.Sprintf("node %s (%s) forced to re-enroll", node.Hostname, node.UUID), identity.Original, identity.Current)
+		adminOKResponse(w, "node forced to re-enroll successfully")
+	default:
+		adminErrorResponse(w, "invalid action", http.StatusBadRequest, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Serialize and send response
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Printf("DebugService: Identity of %s resolved with %s", node.UUID, i.Action)
+	}
+	h.Inc(metricAdminOK)
+}
@@ -72,7 +72,7 @@ func (h *HandlersAdmin) JSONCarvesHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 	// Get environment
-	env, err := h.Envs.Get(envVar)
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
 	if err != nil {
 		log.Printf("error getting environment %s - %v", envVar, err)
 		h.Inc(metricJSONErr)
@@ -91,8 +91,8 @@ func (h *HandlersAdmin) JSONCarvesHandler(w http.ResponseWriter, r *http.Request
 		log.Printf("invalid target %s", target)
 		return
 	}
-	// Retrieve carves for that target
-	qs, err := h.Queries.GetCarves(target, env.ID)
+	// Retrieve carves for that target, as they are listed to the user
+	qs, err := h.listQueries(r.Context(), target, queries.CarveQueryType, env.ID, ctx[sessions.CtxUser])
 	if err != nil {
 		h.Inc(metricJSONErr)
 		log.Printf("error getting query carves %v", err)
@@ -101,7 +101,7 @@ func (h *HandlersAdmin) JSONCarvesHandler(w http.ResponseWriter, r *http.Request
 	// Prepare data to be returned
 	cJSON := []CarveJSON{}
 	for _, q := range qs {
-		c, err := h.Carves.GetByQuery(q.Name, env.ID)
+		c, err := h.Carves.GetByQueryCtx(r.Context(), q.Name, env.ID)
 		if err != nil {
 			log.Printf("error getting carves %v", err)
 			h.Inc(metricJSONErr)
@@ -121,8 +121,13 @@ func (h *HandlersAdmin) JSONCarvesHandler(w http.ResponseWriter, r *http.Request
 		data := make(CarveData)
 		data["path"] = q.Path
 		data["name"] = q.Name
-		// Preparing query targets
-		ts, _ := h.Queries.GetTargets(q.Name)
+		// Locked carves are listed without their path or targets
+		var ts []queries.DistributedQueryTarget
+		if q.Locked {
+			data["path"] = "[restricted]"
+		} else {
+			ts, _ = h.Queries.GetTargets(q.Name)
+		}
 		_ts := []CarveTarget{}
 		for _, t := range ts {
 			_t := CarveTarget{
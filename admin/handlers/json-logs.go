@@ -35,9 +35,11 @@ type CreationTimes struct {
 
 // LogJSON to be used to populate JSON data for a status/result log
 type LogJSON struct {
-	Created CreationTimes `json:"created"`
-	First   string        `json:"first"`
-	Second  string        `json:"second"`
+	Created   CreationTimes `json:"created"`
+	First     string        `json:"first"`
+	Second    string        `json:"second"`
+	Action    string        `json:"action,omitempty"`
+	EventType string        `json:"event_type,omitempty"`
 }
 
 // ReturnedQueryLogs to return a JSON with query logs
@@ -84,7 +86,7 @@ func (h *HandlersAdmin) JSONLogsHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	// Get environment
-	env, err := h.Envs.Get(envVar)
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
 	if err != nil {
 		log.Printf("error getting environment %s - %v", envVar, err)
 		h.Inc(metricJSONErr)
@@ -145,17 +147,31 @@ func (h *HandlersAdmin) JSONLogsHandler(w http.ResponseWriter, r *http.Request)
 			h.Inc(metricJSONErr)
 			return
 		}
-		// Prepare data to be returned
+		// Prepare data to be returned, one entry for each row of batches and snapshots.
+		// Cached logs only keep the time reported by the node.
 		for _, r := range resultLogs {
-			_l := LogJSON{
-				Created: CreationTimes{
-					Display:   utils.PastFutureTimesEpoch(int64(r.UnixTime)),
-					Timestamp: strconv.Itoa(int(r.UnixTime)),
-				},
-				First:  r.Name,
-				Second: string(r.Columns),
+			events, err := r.Events()
+			if err != nil {
+				log.Printf("error parsing result log %v", err)
+			}
+			for _, e := range events {
+				columns, err := json.Marshal(e.Columns)
+				if err != nil {
+					log.Printf("error serializing columns %v", err)
+					continue
+				}
+				_l := LogJSON{
+					Created: CreationTimes{
+						Display:   utils.PastFutureTimesEpoch(e.AgentTime.Unix()),
+						Timestamp: strconv.FormatInt(e.AgentTime.Unix(), 10),
+					},
+					First:     e.Name,
+					Second:    string(columns),
+					Action:    e.Action,
+					EventType: e.EventType,
+				}
+				logJSON = append(logJSON, _l)
 			}
-			logJSON = append(logJSON, _l)
 		}
 	}
 	returned := ReturnedLogs{
@@ -187,6 +203,13 @@ func (h *HandlersAdmin) JSONQueryLogsHandler(w http.ResponseWriter, r *http.Requ
 		h.Inc(metricJSONErr)
 		return
 	}
+	// Results of restricted queries are only for who can see them
+	if !h.canViewName(r.Context(), name, 0, ctx[sessions.CtxUser]) {
+		log.Printf("%s can not see results of query %s", ctx[sessions.CtxUser], name)
+		h.Inc(metricJSONErr)
+		utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusForbidden, ReturnedQueryLogs{Data: []QueryLogJSON{}})
+		return
+	}
 	// Iterate through targets to get logs
 	queryLogJSON := []QueryLogJSON{}
 	// Get logs
@@ -3,9 +3,15 @@ package handlers
 import (
 	"log"
 	"net/http"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/jmpsec/osctrl/admin/sessions"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/jmpsec/osctrl/queries"
 	"github.com/jmpsec/osctrl/settings"
 	"github.com/jmpsec/osctrl/users"
 	"github.com/jmpsec/osctrl/utils"
@@ -27,16 +33,87 @@ type ReturnedNodes struct {
 
 // NodeJSON to be used to populate JSON data for a node
 type NodeJSON struct {
-	Checkbox  string        `json:"checkbox"`
-	UUID      string        `json:"uuid"`
-	Username  string        `json:"username"`
-	Localname string        `json:"localname"`
-	IP        string        `json:"ip"`
-	Platform  string        `json:"platform"`
-	Version   string        `json:"version"`
-	Osquery   string        `json:"osquery"`
-	LastSeen  CreationTimes `json:"lastseen"`
-	FirstSeen CreationTimes `json:"firstseen"`
+	Checkbox    string        `json:"checkbox"`
+	UUID        string        `json:"uuid"`
+	Environment string        `json:"environment"`
+	Username    string        `json:"username"`
+	Localname   string        `json:"localname"`
+	Hostname    string        `json:"hostname"`
+	DisplayName string        `json:"display_name"`
+	Collision   bool          `json:"collision"`
+	IP          string        `json:"ip"`
+	Platform    string        `json:"platform"`
+	Version     string        `json:"version"`
+	Osquery     string        `json:"osquery"`
+	LastSeen    CreationTimes `json:"lastseen"`
+	FirstSeen   CreationTimes `json:"firstseen"`
+	Metadata    string        `json:"metadata"`
+	Skew        SkewJSON      `json:"skew"`
+	Pin         PinJSON       `json:"pin"`
+	Looping     bool          `json:"looping"`
+	Paused      bool          `json:"paused"`
+}
+
+// SkewJSON to show the clock skew of a node, filter is skewed or ok to select flagged nodes
+type SkewJSON struct {
+	Display string `json:"display"`
+	Seconds int64  `json:"seconds"`
+	Filter  string `json:"filter"`
+}
+
+// PinJSON to show the osquery version pinned for a node, filter has the version and off-pin for nodes running another one
+type PinJSON struct {
+	Pinned string `json:"pinned"`
+	OffPin bool   `json:"off_pin"`
+	Filter string `json:"filter"`
+}
+
+// Helper to get the display name of a node for the nodes table, the localname for nodes not normalized yet
+func nodeDisplayName(node nodes.OsqueryNode) string {
+	if node.DisplayName != "" {
+		return node.DisplayName
+	}
+	return node.Localname
+}
+
+// Helper to get the pinned osquery version of a node for the nodes table
+func nodePin(node nodes.OsqueryNode, env environments.TLSEnvironment) PinJSON {
+	pin := PinJSON{
+		Pinned: environments.PinnedVersion(env, node.Platform),
+		OffPin: environments.OffPin(env, node.Platform, node.OsqueryVersion),
+		Filter: node.OsqueryVersion,
+	}
+	if pin.OffPin {
+		pin.Filter += " off-pin"
+	}
+	return pin
+}
+
+// Helper to get the clock skew of a node for the nodes table
+func nodeSkew(node nodes.OsqueryNode, threshold int64) SkewJSON {
+	if node.AgentTime.IsZero() {
+		return SkewJSON{Display: "-", Filter: "ok"}
+	}
+	skew := SkewJSON{
+		Display: nodes.FormatSkew(node.ClockSkew),
+		Seconds: node.ClockSkew,
+		Filter:  "ok",
+	}
+	if nodes.ClockSkewed(node, threshold) {
+		skew.Filter = "skewed"
+	}
+	return skew
+}
+
+// Helper to format the enroll metadata of a node as name=value pairs, to search nodes by them
+func enrollMetadataSearch(node nodes.OsqueryNode) string {
+	values := nodes.EnrollMetadataValues(node)
+	pairs := make([]string, 0, len(values))
+	for name, value := range values {
+		pairs = append(pairs, name+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, " ")
 }
 
 // JSONEnvironmentHandler - Handler for JSON endpoints by environment
@@ -58,7 +135,7 @@ func (h *HandlersAdmin) JSONEnvironmentHandler(w http.ResponseWriter, r *http.Re
 		return
 	}
 	// Get environment
-	env, err := h.Envs.Get(envVar)
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
 	if err != nil {
 		log.Printf("error getting environment %s - %v", envVar, err)
 		h.Inc(metricJSONErr)
@@ -85,23 +162,49 @@ func (h *HandlersAdmin) JSONEnvironmentHandler(w http.ResponseWriter, r *http.Re
 		h.Inc(metricJSONErr)
 		return
 	}
-	nodes, err := h.Nodes.GetByEnv(env.Name, target, h.Settings.InactiveHours())
+	// Hosts flagged as enrolling in a loop
+	loopCfg := nodes.NewEnrollLoopConfig(env.EnrollLoopLimit, env.EnrollLoopQuiet, env.EnrollBackoff)
+	looping, err := h.Nodes.LoopingUUIDs(env.ID, time.Now(), loopCfg.Quiet)
+	if err != nil {
+		log.Printf("error getting enroll loops %v", err)
+		h.Inc(metricJSONErr)
+		return
+	}
+	// Paused nodes do not get queries nor carves
+	paused, err := h.Nodes.PausedUUIDs(env.ID, time.Now())
+	if err != nil {
+		log.Printf("error getting paused nodes %v", err)
+		h.Inc(metricJSONErr)
+		return
+	}
+	// Nodes sharing their display name are flagged, they are never merged by normalization
+	collisions, err := h.Nodes.NameCollisions(env.ID)
+	if err != nil {
+		log.Printf("error getting name collisions %v", err)
+		h.Inc(metricJSONErr)
+		return
+	}
+	nodes, err := h.Nodes.GetByEnvCtx(r.Context(), env.Name, target, h.Settings.InactiveHours())
 	if err != nil {
 		log.Printf("error getting nodes %v", err)
 		h.Inc(metricJSONErr)
 		return
 	}
 	// Prepare data to be returned
+	threshold := h.Settings.ClockSkewSeconds()
 	nJSON := []NodeJSON{}
 	for _, n := range nodes {
 		nj := NodeJSON{
-			UUID:      n.UUID,
-			Username:  n.Username,
-			Localname: n.Localname,
-			IP:        n.IPAddress,
-			Platform:  n.Platform,
-			Version:   n.PlatformVersion,
-			Osquery:   n.OsqueryVersion,
+			UUID:        n.UUID,
+			Environment: n.Environment,
+			Username:    n.Username,
+			Localname:   n.Localname,
+			Hostname:    n.Hostname,
+			DisplayName: nodeDisplayName(n),
+			IP:          n.IPAddress,
+			Platform:    n.Platform,
+			Version:     n.PlatformVersion,
+			Osquery:     n.OsqueryVersion,
 			LastSeen: CreationTimes{
 				Display:   utils.PastFutureTimes(n.UpdatedAt),
 				Timestamp: utils.TimeTimestamp(n.UpdatedAt),
@@ -110,6 +213,12 @@ func (h *HandlersAdmin) JSONEnvironmentHandler(w http.ResponseWriter, r *http.Re
 				Display:   utils.PastFutureTimes(n.CreatedAt),
 				Timestamp: utils.TimeTimestamp(n.CreatedAt),
 			},
+			Metadata:  enrollMetadataSearch(n),
+			Skew:      nodeSkew(n, threshold),
+			Pin:       nodePin(n, env),
+			Looping:   looping[n.UUID],
+			Paused:    paused[n.UUID],
+			Collision: len(collisions[n.DisplayName]) > 1,
 		}
 		nJSON = append(nJSON, nj)
 	}
@@ -160,17 +269,32 @@ func (h *HandlersAdmin) JSONPlatformHandler(w http.ResponseWriter, r *http.Reque
 		h.Inc(metricJSONErr)
 		return
 	}
+	// Nodes of all environments, to get their pinned versions
+	envs, err := h.Envs.All()
+	if err != nil {
+		log.Printf("error getting environments %v", err)
+		h.Inc(metricJSONErr)
+		return
+	}
+	envsByID := make(map[uint]environments.TLSEnvironment, len(envs))
+	for _, e := range envs {
+		envsByID[e.ID] = e
+	}
 	// Prepare data to be returned
+	threshold := h.Settings.ClockSkewSeconds()
 	var nJSON []NodeJSON
 	for _, n := range nodes {
 		nj := NodeJSON{
-			UUID:      n.UUID,
-			Username:  n.Username,
-			Localname: n.Localname,
-			IP:        n.IPAddress,
-			Platform:  n.Platform,
-			Version:   n.PlatformVersion,
-			Osquery:   n.OsqueryVersion,
+			UUID:        n.UUID,
+			Environment: n.Environment,
+			Username:    n.Username,
+			Localname:   n.Localname,
+			Hostname:    n.Hostname,
+			DisplayName: nodeDisplayName(n),
+			IP:          n.IPAddress,
+			Platform:    n.Platform,
+			Version:     n.PlatformVersion,
+			Osquery:     n.OsqueryVersion,
 			LastSeen: CreationTimes{
 				Display:   utils.PastFutureTimes(n.UpdatedAt),
 				Timestamp: utils.TimeTimestamp(n.UpdatedAt),
@@ -179,6 +303,9 @@ func (h *HandlersAdmin) JSONPlatformHandler(w http.ResponseWriter, r *http.Reque
 				Display:   utils.PastFutureTimes(n.CreatedAt),
 				Timestamp: utils.TimeTimestamp(n.CreatedAt),
 			},
+			Metadata: enrollMetadataSearch(n),
+			Skew:     nodeSkew(n, threshold),
+			Pin:      nodePin(n, envsByID[n.EnvironmentID]),
 		}
 		nJSON = append(nJSON, nj)
 	}
@@ -189,3 +316,54 @@ func (h *HandlersAdmin) JSONPlatformHandler(w http.ResponseWriter, r *http.Reque
 	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, returned)
 	h.Inc(metricJSONOK)
 }
+
+// JSONCoverageHandler for the estimate of the target nodes of an environment checking in within a window
+func (h *HandlersAdmin) JSONCoverageHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricJSONReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), false)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		log.Println("error getting environment")
+		h.Inc(metricJSONErr)
+		return
+	}
+	// Get environment
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		log.Printf("error getting environment %s - %v", envVar, err)
+		h.Inc(metricJSONErr)
+		return
+	}
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Check permissions
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.UserLevel, env.UUID) {
+		log.Printf("%s has insuficient permissions", ctx[sessions.CtxUser])
+		h.Inc(metricJSONErr)
+		return
+	}
+	q := r.URL.Query()
+	hours, err := queries.ParseExpiration(q.Get("window"))
+	if err != nil {
+		adminErrorResponse(w, "invalid window", http.StatusBadRequest, err)
+		h.Inc(metricJSONErr)
+		return
+	}
+	if hours == 0 {
+		hours = h.queryExpiration(env).Default
+	}
+	all, err := h.Nodes.GetByEnvCtx(r.Context(), env.Name, "all", 0)
+	if err != nil {
+		log.Printf("error getting nodes %v", err)
+		h.Inc(metricJSONErr)
+		return
+	}
+	targets := nodes.CoverageTargets(all, strings.Split(q.Get("targets"), ","), strings.Split(q.Get("platforms"), ","))
+	estimate := nodes.EstimateCoverage(targets, time.Now(), time.Duration(hours)*time.Hour)
+	estimate.Environment = env.Name
+	// Serve JSON
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, estimate)
+	h.Inc(metricJSONOK)
+}
@@ -50,6 +50,8 @@ type QueryJSON struct {
 	Status   string        `json:"status"`
 	Progress QueryProgress `json:"progress"`
 	Targets  []QueryTarget `json:"targets"`
+	Ticket   QueryData     `json:"ticket"`
+	Labels   []string      `json:"labels"`
 }
 
 // SavedJSON to be used to populate JSON data for a saved query
@@ -84,8 +86,14 @@ func (h *HandlersAdmin) JSONQueryJSON(q queries.DistributedQuery, env string) Qu
 	if q.Completed {
 		status = queries.StatusComplete
 	}
-	// Preparing query targets
-	ts, _ := h.Queries.GetTargets(q.Name)
+	// Locked queries are listed without their SQL, links or targets
+	var ts []queries.DistributedQueryTarget
+	if q.Locked {
+		data["link"] = ""
+		data["locked"] = "true"
+	} else {
+		ts, _ = h.Queries.GetTargets(q.Name)
+	}
 	_ts := []QueryTarget{}
 	for _, t := range ts {
 		_t := QueryTarget{
@@ -94,6 +102,10 @@ func (h *HandlersAdmin) JSONQueryJSON(q queries.DistributedQuery, env string) Qu
 		}
 		_ts = append(_ts, _t)
 	}
+	// Prepare ticket data
+	ticket := make(QueryData)
+	ticket["id"] = q.TicketID
+	ticket["url"] = q.TicketURL
 	return QueryJSON{
 		Creator: q.Creator,
 		Name:    q.Name,
@@ -105,6 +117,8 @@ func (h *HandlersAdmin) JSONQueryJSON(q queries.DistributedQuery, env string) Qu
 		Status:   status,
 		Progress: progress,
 		Targets:  _ts,
+		Ticket:   ticket,
+		Labels:   q.LabelList(),
 	}
 }
 
@@ -142,7 +156,7 @@ func (h *HandlersAdmin) JSONQueryHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	// Get environment
-	env, err := h.Envs.Get(envVar)
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
 	if err != nil {
 		log.Printf("error getting environment %s - %v", envVar, err)
 		h.Inc(metricJSONErr)
@@ -183,13 +197,20 @@ func (h *HandlersAdmin) JSONQueryHandler(w http.ResponseWriter, r *http.Request)
 		h.Inc(metricJSONOK)
 		return
 	}
-	// If we are here, retrieve distributed queries for that target
-	qs, err := h.Queries.GetQueries(target, env.ID)
+	// If we are here, retrieve distributed queries for that target, as they are listed to the user
+	qs, err := h.listQueries(r.Context(), target, queries.StandardQueryType, env.ID, ctx[sessions.CtxUser])
 	if err != nil {
 		log.Printf("error getting queries %v", err)
 		h.Inc(metricJSONErr)
 		return
 	}
+	// Filter by label and ticket, the labels are loaded for the table
+	qs, err = h.Queries.FilterContext(r.Context(), qs, queries.ParseContextFilter(r.URL.Query().Get("label"), r.URL.Query().Get("ticket")))
+	if err != nil {
+		log.Printf("error filtering queries %v", err)
+		h.Inc(metricJSONErr)
+		return
+	}
 	// Prepare data to be returned
 	qJSON := []QueryJSON{}
 	for _, q := range qs {
@@ -3,9 +3,11 @@ package handlers
 import (
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/jmpsec/osctrl/admin/sessions"
+	"github.com/jmpsec/osctrl/environments"
 	"github.com/jmpsec/osctrl/nodes"
 	"github.com/jmpsec/osctrl/settings"
 	"github.com/jmpsec/osctrl/users"
@@ -52,7 +54,7 @@ func (h *HandlersAdmin) JSONStatsHandler(w http.ResponseWriter, r *http.Request)
 	var err error
 	if target == "environment" {
 		// Verify identifier
-		env, err := h.Envs.Get(identifier)
+		env, err := h.Envs.GetCtx(r.Context(), identifier)
 		if err != nil {
 			log.Printf("error getting environment %s - %v", identifier, err)
 			h.Inc(metricJSONErr)
@@ -87,3 +89,54 @@ func (h *HandlersAdmin) JSONStatsHandler(w http.ResponseWriter, r *http.Request)
 	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, stats)
 	h.Inc(metricJSONOK)
 }
+
+// JSONHistoryHandler for the node count history of an environment in JSON, filtered with metric and from
+func (h *HandlersAdmin) JSONHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), false)
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		h.Inc(metricAdminErr)
+		log.Println("error getting environment")
+		return
+	}
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		log.Printf("error getting environment %s - %v", envVar, err)
+		h.Inc(metricJSONErr)
+		return
+	}
+	// Check permissions
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.UserLevel, env.UUID) {
+		log.Printf("%s has insuficient permissions", ctx[sessions.CtxUser])
+		h.Inc(metricJSONErr)
+		return
+	}
+	metric := r.URL.Query().Get("metric")
+	if metric == "" {
+		metric = nodes.HistoryActive
+	}
+	if !nodes.ValidHistoryMetric(metric) {
+		log.Printf("invalid history metric %s", metric)
+		h.Inc(metricJSONErr)
+		return
+	}
+	now := time.Now()
+	from, err := environments.ParseSince(r.URL.Query().Get("from"), now)
+	if err != nil || from.IsZero() {
+		from = now.AddDate(0, 0, -nodes.DefaultHistoryDays)
+	}
+	history, err := h.Nodes.CountHistory(env.ID, metric, from, now)
+	if err != nil {
+		log.Printf("error getting history %v", err)
+		h.Inc(metricJSONErr)
+		return
+	}
+	// Serve JSON
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, history)
+	h.Inc(metricJSONOK)
+}
@@ -4,9 +4,13 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
 
 	"github.com/jmpsec/osctrl/admin/sessions"
 	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/tags"
 	"github.com/jmpsec/osctrl/users"
 	"github.com/jmpsec/osctrl/utils"
 )
@@ -34,3 +38,39 @@ func (h *HandlersAdmin) JSONTagsHandler(w http.ResponseWriter, r *http.Request)
 	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, tags)
 	h.Inc(metricJSONOK)
 }
+
+// TagJobJSON to return the progress of a tag job
+type TagJobJSON struct {
+	tags.TagJob
+	Progress int `json:"progress"`
+}
+
+// JSONTagJobHandler for the progress and counts of a tag job in JSON
+func (h *HandlersAdmin) JSONTagJobHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), false)
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Check permissions
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.AdminLevel, users.NoEnvironment) {
+		adminErrorResponse(w, fmt.Sprintf("%s has insuficient permissions", ctx[sessions.CtxUser]), http.StatusForbidden, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	vars := mux.Vars(r)
+	id, err := strconv.ParseUint(vars["id"], 10, 64)
+	if err != nil {
+		adminErrorResponse(w, "invalid tag job", http.StatusBadRequest, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	job, err := h.Tags.GetJob(uint(id))
+	if err != nil {
+		adminErrorResponse(w, "error getting tag job", http.StatusNotFound, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Serve JSON
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, TagJobJSON{TagJob: job, Progress: job.Progress()})
+	h.Inc(metricJSONOK)
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/admin/sessions"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+// NodeConfigHistoryGETHandler for GET requests for /node/{uuid}/config-history to compare configurations served to a node
+func (h *HandlersAdmin) NodeConfigHistoryGETHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), false)
+	vars := mux.Vars(r)
+	// Extract uuid
+	uuid, ok := vars["uuid"]
+	if !ok {
+		h.Inc(metricAdminErr)
+		log.Println("error getting uuid")
+		return
+	}
+	// Get node by UUID
+	node, err := h.Nodes.GetByUUID(uuid)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting node %v", err)
+		return
+	}
+	// Get environment
+	env, err := h.Envs.GetCtx(r.Context(), node.Environment)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environment: %v", err)
+		return
+	}
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Check permissions
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.UserLevel, env.UUID) {
+		log.Printf("%s has insuficient permissions", ctx[sessions.CtxUser])
+		h.Inc(metricAdminErr)
+		return
+	}
+	lineage, err := h.Envs.Lineage(node.UUID)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting configuration lineage: %v", err)
+		return
+	}
+	// Missing points compare the latest with the one before
+	var diff environments.LineageDiff
+	if len(lineage) > 0 {
+		q := r.URL.Query()
+		from, to := environments.LineagePoints(lineage, q.Get("from"), q.Get("to"))
+		diff, err = h.Envs.DiffLineage(from, to)
+		if err != nil {
+			h.Inc(metricAdminErr)
+			log.Printf("error rendering configuration lineage: %v", err)
+			return
+		}
+	}
+	// Prepare template
+	tempateFiles := h.NewTemplateFiles(h.TemplatesFolder, "node-config-history.html").filepaths
+	t, err := template.ParseFiles(tempateFiles...)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting config history template: %v", err)
+		return
+	}
+	// Get stats for all environments
+	envAll, err := h.Envs.AllCtx(r.Context())
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environments %v", err)
+		return
+	}
+	// Get stats for all platforms
+	platforms, err := h.Nodes.GetAllPlatforms()
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting platforms: %v", err)
+		return
+	}
+	// Prepare template data
+	templateData := NodeConfigHistoryTemplateData{
+		Title:        "Configuration History " + node.Hostname,
+		EnvUUID:      env.UUID,
+		Node:         node,
+		Lineage:      lineage,
+		Diff:         diff,
+		Metadata:     h.EnvTemplateMetadata(ctx, h.ServiceVersion, env.UUID),
+		LeftMetadata: AsideLeftMetadata{EnvUUID: env.UUID},
+		Environments: h.allowedEnvironments(ctx[sessions.CtxUser], envAll),
+		Platforms:    platforms,
+	}
+	if err := t.Execute(w, templateData); err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("template error %v", err)
+		return
+	}
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Node config history template served")
+	}
+	h.Inc(metricAdminOK)
+}
@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/admin/sessions"
+	"github.com/jmpsec/osctrl/cache"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+// Helper to get the thresholds to propose duplicate nodes from settings
+func (h *HandlersAdmin) mergeThresholds() nodes.MergeThresholds {
+	return nodes.NewMergeThresholds(h.Settings.DuplicateScore(), h.Settings.DuplicateHostnameDistance())
+}
+
+// NodeMergesGETHandler for GET requests for /node-merges/{environment}, the review queue of duplicate nodes
+func (h *HandlersAdmin) NodeMergesGETHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), false)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["environment"]
+	if !ok {
+		h.Inc(metricAdminErr)
+		log.Println("error getting environment")
+		return
+	}
+	// Get environment
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environment: %v", err)
+		return
+	}
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Check permissions
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.AdminLevel, env.UUID) {
+		log.Printf("%s has insuficient permissions", ctx[sessions.CtxUser])
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Proposed duplicates by default, all with ?state=all
+	state := r.URL.Query().Get("state")
+	switch state {
+	case "":
+		state = nodes.MergeProposed
+	case "all":
+		state = ""
+	}
+	merges, err := h.Nodes.GetMerges(env.ID, state)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting node merges: %v", err)
+		return
+	}
+	// Custom functions to handle formatting
+	funcMap := template.FuncMap{
+		"pastFutureTimes": utils.PastFutureTimes,
+	}
+	// Prepare template
+	tempateFiles := h.NewTemplateFiles(h.TemplatesFolder, "node-merges.html").filepaths
+	t, err := template.New("node-merges.html").Funcs(funcMap).ParseFiles(tempateFiles...)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting node merges template: %v", err)
+		return
+	}
+	// Get stats for all environments
+	envAll, err := h.Envs.AllCtx(r.Context())
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environments %v", err)
+		return
+	}
+	// Get stats for all platforms
+	platforms, err := h.Nodes.GetAllPlatforms()
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting platforms: %v", err)
+		return
+	}
+	// Prepare template data
+	templateData := NodeMergesTemplateData{
+		Title:        env.Name + " Duplicate Nodes",
+		EnvName:      env.Name,
+		EnvUUID:      env.UUID,
+		State:        state,
+		Thresholds:   h.mergeThresholds(),
+		Window:       nodes.MergeWindow(h.Settings.MergeSplitDays()),
+		Merges:       merges,
+		Now:          time.Now(),
+		Metadata:     h.EnvTemplateMetadata(ctx, h.ServiceVersion, env.UUID),
+		LeftMetadata: AsideLeftMetadata{EnvUUID: env.UUID},
+		Environments: h.allowedEnvironments(ctx[sessions.CtxUser], envAll),
+		Platforms:    platforms,
+	}
+	if err := t.Execute(w, templateData); err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("template error %v", err)
+		return
+	}
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Node merges template served")
+	}
+	h.Inc(metricAdminOK)
+}
+
+// NodeMergesPOSTHandler for POST requests to approve, reject or split merges of duplicate nodes, and to merge
+// nodes manually
+func (h *HandlersAdmin) NodeMergesPOSTHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), true)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["environment"]
+	if !ok {
+		adminErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Get environment
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		adminErrorResponse(w, "error getting environment", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Check permissions
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.AdminLevel, env.UUID) {
+		adminErrorResponse(w, fmt.Sprintf("%s has insuficient permissions", ctx[sessions.CtxUser]), http.StatusForbidden, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	var m NodeMergeRequest
+	// Parse request JSON body
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Decoding POST body")
+	}
+	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+		adminErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Check CSRF Token
+	if !sessions.CheckCSRFToken(ctx[sessions.CtxCSRF], m.CSRFToken) {
+		adminErrorResponse(w, "invalid CSRF token", http.StatusInternalServerError, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Reviewed merges must be in the environment
+	if m.Action != "merge" {
+		merge, err := h.Nodes.GetMerge(m.ID)
+		if err != nil || merge.EnvironmentID != env.ID {
+			adminErrorResponse(w, "merge not found", http.StatusNotFound, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+	}
+	var merge nodes.NodeMerge
+	switch m.Action {
+	case "merge":
+		// Both nodes must be in the environment
+		for _, uuid := range []string{m.Into, m.From} {
+			if _, err := h.Nodes.GetByUUIDEnv(uuid, env.ID); err != nil {
+				adminErrorResponse(w, "node not found", http.StatusNotFound, err)
+				h.Inc(metricAdminErr)
+				return
+			}
+		}
+		merge, err = h.Nodes.ManualMerge(m.Into, m.From, ctx[sessions.CtxUser], h.mergeThresholds())
+	case "approve":
+		merge, err = h.Nodes.ApproveMerge(m.ID, ctx[sessions.CtxUser])
+	case "reject":
+		merge, err = h.Nodes.RejectMerge(m.ID, ctx[sessions.CtxUser])
+	case "split":
+		merge, err = h.Nodes.SplitMerge(m.ID, ctx[sessions.CtxUser], nodes.MergeWindow(h.Settings.MergeSplitDays()))
+	default:
+		adminErrorResponse(w, "invalid action", http.StatusBadRequest, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	if err != nil {
+		adminErrorResponse(w, "error with node merge", http.StatusBadRequest, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	if m.Action != "reject" {
+		h.invalidateResponses(cache.ResponseGroupNodes)
+	}
+	h.recordActivity(env, environments.ActivityMerges, ctx[sessions.CtxUser], nodes.MergeSummary(merge), "", merge.Evidence)
+	// Serialize and send response
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Printf("DebugService: Node merge %d is %s", merge.ID, merge.State)
+	}
+	adminOKResponse(w, "node merge "+merge.State+" successfully")
+	h.Inc(metricAdminOK)
+}
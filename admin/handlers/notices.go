@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jmpsec/osctrl/admin/sessions"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+// Helper to get the notices shown to a user in a page, and the ones that need a confirmation to run queries and carves
+func (h *HandlersAdmin) templateNotices(username, envUUID string) ([]users.Notice, []users.Notice) {
+	now := time.Now()
+	notices, err := h.Users.ActiveNotices(now)
+	if err != nil {
+		log.Printf("error getting notices %v", err)
+		return []users.Notice{}, []users.Notice{}
+	}
+	acked, err := h.Users.AckedNotices(username)
+	if err != nil {
+		log.Printf("error getting acknowledged notices %v", err)
+	}
+	confirm := []users.Notice{}
+	if envUUID != "" {
+		confirm = users.ConfirmNotices(notices, envUUID, now)
+	}
+	return users.VisibleNotices(notices, envUUID, acked, now), confirm
+}
+
+// NoticesGETHandler for GET requests for /notices
+func (h *HandlersAdmin) NoticesGETHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), false)
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Check permissions
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.AdminLevel, users.NoEnvironment) {
+		log.Printf("%s has insuficient permissions", ctx[sessions.CtxUser])
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Custom functions to handle formatting
+	funcMap := template.FuncMap{
+		"pastFutureTimes": utils.PastFutureTimes,
+	}
+	// Prepare template
+	tempateFiles := h.NewTemplateFiles(h.TemplatesFolder, "notices.html").filepaths
+	t, err := template.New("notices.html").Funcs(funcMap).ParseFiles(tempateFiles...)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting notices template: %v", err)
+		return
+	}
+	// Active notices first, then the expired and removed ones for audit
+	all, err := h.Users.NoticesHistory()
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting notices: %v", err)
+		return
+	}
+	now := time.Now()
+	active := []users.Notice{}
+	history := []users.Notice{}
+	for _, n := range all {
+		if n.Active(now) {
+			active = append(active, n)
+		} else {
+			history = append(history, n)
+		}
+	}
+	acks, err := h.Users.NoticeAcks()
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting acknowledgments: %v", err)
+		return
+	}
+	envAll, err := h.Envs.All()
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environments: %v", err)
+		return
+	}
+	// Get stats for all platforms
+	platforms, err := h.Nodes.GetAllPlatforms()
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting platforms: %v", err)
+		return
+	}
+	envNames := make(map[string]string)
+	for _, e := range envAll {
+		envNames[e.UUID] = e.Name
+	}
+	// Prepare template data
+	templateData := NoticesTemplateData{
+		Title:        "Notices",
+		Active:       active,
+		History:      history,
+		Acks:         acks,
+		Environments: envAll,
+		Platforms:    platforms,
+		EnvNames:     envNames,
+		Severities:   users.NoticeSeverities,
+		Metadata:     h.TemplateMetadata(ctx, h.ServiceVersion),
+	}
+	if err := t.Execute(w, templateData); err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("template error %v", err)
+		return
+	}
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Notices template served")
+	}
+	h.Inc(metricAdminOK)
+}
+
+// NoticesPOSTHandler for POST requests for /notices, all users can acknowledge notices
+func (h *HandlersAdmin) NoticesPOSTHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), true)
+	var nr NoticeRequest
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Parse request JSON body
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Decoding POST body")
+	}
+	if err := json.NewDecoder(r.Body).Decode(&nr); err != nil {
+		adminErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Check CSRF Token
+	if !sessions.CheckCSRFToken(ctx[sessions.CtxCSRF], nr.CSRFToken) {
+		adminErrorResponse(w, "invalid CSRF token", http.StatusInternalServerError, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Check permissions
+	if nr.Action != "ack" && !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.AdminLevel, users.NoEnvironment) {
+		adminErrorResponse(w, fmt.Sprintf("%s has insuficient permissions", ctx[sessions.CtxUser]), http.StatusForbidden, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	notice := users.Notice{
+		Message:        nr.Message,
+		Severity:       nr.Severity,
+		RequireAck:     nr.RequireAck,
+		ConfirmActions: nr.ConfirmActions,
+		Creator:        ctx[sessions.CtxUser],
+	}
+	if nr.Action == "create" || nr.Action == "update" {
+		expires, err := users.ParseNoticeExpiry(nr.ExpiresAt, time.Now())
+		if err != nil {
+			adminErrorResponse(w, "invalid expiration", http.StatusBadRequest, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		notice.ExpiresAt = expires
+		var envUUIDs []string
+		for _, e := range nr.Environments {
+			env, err := h.Envs.Get(e)
+			if err != nil {
+				adminErrorResponse(w, "error getting environment "+e, http.StatusBadRequest, err)
+				h.Inc(metricAdminErr)
+				return
+			}
+			envUUIDs = append(envUUIDs, env.UUID)
+		}
+		notice.Environments = strings.Join(envUUIDs, ",")
+	}
+	switch nr.Action {
+	case "create":
+		created, err := h.Users.NewNotice(notice)
+		if err != nil {
+			adminErrorResponse(w, "error creating notice", http.StatusBadRequest, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		log.Printf("notice %d created by %s", created.ID, ctx[sessions.CtxUser])
+		adminOKResponse(w, "notice created successfully")
+	case "update":
+		if _, err := h.Users.UpdateNotice(nr.ID, notice); err != nil {
+			adminErrorResponse(w, "error updating notice", http.StatusBadRequest, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		log.Printf("notice %d updated by %s", nr.ID, ctx[sessions.CtxUser])
+		adminOKResponse(w, "notice updated successfully")
+	case "delete":
+		if err := h.Users.DeleteNotice(nr.ID, ctx[sessions.CtxUser]); err != nil {
+			adminErrorResponse(w, "error removing notice", http.StatusBadRequest, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		log.Printf("notice %d removed by %s", nr.ID, ctx[sessions.CtxUser])
+		adminOKResponse(w, "notice removed successfully")
+	case "ack":
+		if err := h.Users.AckNotice(nr.ID, ctx[sessions.CtxUser]); err != nil {
+			adminErrorResponse(w, "error acknowledging notice", http.StatusBadRequest, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		adminOKResponse(w, "notice acknowledged")
+	default:
+		adminErrorResponse(w, "invalid action", http.StatusBadRequest, fmt.Errorf("invalid action %s", nr.Action))
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Serialize and send response
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Notices response sent")
+	}
+	h.Inc(metricAdminOK)
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jmpsec/osctrl/admin/sessions"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+// ScheduleOverridePOSTHandler for POST requests to set and remove schedule overrides of a node
+func (h *HandlersAdmin) ScheduleOverridePOSTHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), true)
+	var o ScheduleOverrideRequest
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Parse request JSON body
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Decoding POST body")
+	}
+	if err := json.NewDecoder(r.Body).Decode(&o); err != nil {
+		adminErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Check CSRF Token
+	if !sessions.CheckCSRFToken(ctx[sessions.CtxCSRF], o.CSRFToken) {
+		adminErrorResponse(w, "invalid CSRF token", http.StatusInternalServerError, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Get node and environment
+	node, err := h.Nodes.GetByUUID(o.UUID)
+	if err != nil {
+		adminErrorResponse(w, "error getting node", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	env, err := h.Envs.GetCtx(r.Context(), node.Environment)
+	if err != nil {
+		adminErrorResponse(w, "error getting environment", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Check permissions, overrides change the configuration of nodes
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.AdminLevel, env.UUID) {
+		adminErrorResponse(w, fmt.Sprintf("%s has insuficient permissions", ctx[sessions.CtxUser]), http.StatusForbidden, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	switch o.Action {
+	case "set":
+		expires := time.Now().Add(time.Duration(o.Hours) * time.Hour)
+		if _, err := h.Envs.SetScheduleOverride(env, node.UUID, node.Platform, o.Query, o.Interval, expires, ctx[sessions.CtxUser]); err != nil {
+			adminErrorResponse(w, "error setting schedule override", http.StatusBadRequest, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		adminOKResponse(w, "schedule override set successfully")
+	case "delete":
+		override, err := h.Envs.GetScheduleOverride(o.ID)
+		if err != nil || !strings.EqualFold(override.UUID, node.UUID) {
+			adminErrorResponse(w, "schedule override not found", http.StatusNotFound, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		if err := h.Envs.DeleteScheduleOverride(override.ID); err != nil {
+			adminErrorResponse(w, "error deleting schedule override", http.StatusInternalServerError, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		adminOKResponse(w, "schedule override deleted successfully")
+	default:
+		adminErrorResponse(w, "invalid action", http.StatusBadRequest, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Serialize and send response
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Schedule override response sent")
+	}
+	h.Inc(metricAdminOK)
+}
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/admin/sessions"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+// PackSyncGETHandler for GET requests for /pack-sync/{environment}, with the pack source and its synced packs
+func (h *HandlersAdmin) PackSyncGETHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), false)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["environment"]
+	if !ok {
+		h.Inc(metricAdminErr)
+		log.Println("error getting environment")
+		return
+	}
+	// Get environment
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environment: %v", err)
+		return
+	}
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Check permissions
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.AdminLevel, env.UUID) {
+		log.Printf("%s has insuficient permissions", ctx[sessions.CtxUser])
+		h.Inc(metricAdminErr)
+		return
+	}
+	status, err := h.Envs.PackSyncStatus(env.ID)
+	if err != nil && !errors.Is(err, environments.ErrNoPackSource) {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting pack source: %v", err)
+		return
+	}
+	// Custom functions to handle formatting
+	funcMap := template.FuncMap{
+		"pastFutureTimes": utils.PastFutureTimes,
+	}
+	// Prepare template
+	tempateFiles := h.NewTemplateFiles(h.TemplatesFolder, "pack-sync.html").filepaths
+	t, err := template.New("pack-sync.html").Funcs(funcMap).ParseFiles(tempateFiles...)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting pack sync template: %v", err)
+		return
+	}
+	// Get stats for all environments
+	envAll, err := h.Envs.AllCtx(r.Context())
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environments %v", err)
+		return
+	}
+	// Get stats for all platforms
+	platforms, err := h.Nodes.GetAllPlatforms()
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting platforms: %v", err)
+		return
+	}
+	// Prepare template data
+	templateData := PackSyncTemplateData{
+		Title:        env.Name + " Pack Sync",
+		EnvName:      env.Name,
+		EnvUUID:      env.UUID,
+		HasSource:    status.Source.ID != 0,
+		Source:       status.Source,
+		Packs:        status.Packs,
+		Conflicts:    status.Conflicts,
+		AuthKinds:    []string{environments.PackAuthNone, environments.PackAuthToken, environments.PackAuthSSH},
+		Metadata:     h.EnvTemplateMetadata(ctx, h.ServiceVersion, env.UUID),
+		LeftMetadata: AsideLeftMetadata{EnvUUID: env.UUID},
+		Environments: h.allowedEnvironments(ctx[sessions.CtxUser], envAll),
+		Platforms:    platforms,
+	}
+	if err := t.Execute(w, templateData); err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("template error %v", err)
+		return
+	}
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Pack sync template served")
+	}
+	h.Inc(metricAdminOK)
+}
+
+// PackSyncPOSTHandler for POST requests to manage the pack source of an environment, sync it and resolve its conflicts
+func (h *HandlersAdmin) PackSyncPOSTHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), true)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["environment"]
+	if !ok {
+		h.Inc(metricAdminErr)
+		log.Println("error getting environment")
+		return
+	}
+	// Get environment
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environment: %v", err)
+		return
+	}
+	var f PackSyncRequest
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Parse request JSON body
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Decoding POST body")
+	}
+	if err := json.NewDecoder(r.Body).Decode(&f); err != nil {
+		adminErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Check permissions
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.AdminLevel, env.UUID) {
+		adminErrorResponse(w, fmt.Sprintf("%s has insuficient permissions", ctx[sessions.CtxUser]), http.StatusForbidden, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Check CSRF Token
+	if !sessions.CheckCSRFToken(ctx[sessions.CtxCSRF], f.CSRFToken) {
+		adminErrorResponse(w, "invalid CSRF token", http.StatusInternalServerError, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	before, _ := h.Envs.GetPackSource(env.ID)
+	switch f.Action {
+	case "configure":
+		source, err := h.Envs.SetPackSource(environments.PackSource{
+			EnvironmentID: env.ID,
+			URL:           f.URL,
+			Branch:        f.Branch,
+			PathGlob:      f.PathGlob,
+			AuthKind:      f.AuthKind,
+			Credential:    f.Credential,
+			Interval:      f.Interval,
+			Enabled:       f.Enabled,
+			CreatedBy:     ctx[sessions.CtxUser],
+		})
+		if err != nil {
+			adminErrorResponse(w, "error setting pack source", http.StatusBadRequest, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		h.recordActivity(env, environments.ActivityPackSync, ctx[sessions.CtxUser], "pack source set to "+source.URL+"@"+source.Branch, before.String(), source.String())
+		adminOKResponse(w, "pack source saved successfully")
+	case "sync":
+		if err := h.Envs.RequestPackSync(env.ID, ctx[sessions.CtxUser]); err != nil {
+			adminErrorResponse(w, "error requesting pack sync", http.StatusBadRequest, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		adminOKResponse(w, "pack sync requested, it runs in the background")
+	case "resolve":
+		if err := h.Envs.ResolvePackConflict(env.ID, f.Pack, f.Resolution, ctx[sessions.CtxUser]); err != nil {
+			adminErrorResponse(w, "error resolving pack conflict", http.StatusBadRequest, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		msg := "pack " + f.Pack + " managed manually"
+		if f.Resolution == environments.PackRepoWins {
+			msg = "pack " + f.Pack + " synced from the repository"
+		} else if f.Resolution == "" {
+			msg = "conflicts of pack " + f.Pack + " need to be resolved"
+		}
+		h.recordActivity(env, environments.ActivityPackSync, ctx[sessions.CtxUser], msg, "", "")
+		adminOKResponse(w, msg)
+	case "delete":
+		if err := h.Envs.DeletePackSource(env.ID); err != nil {
+			adminErrorResponse(w, "error deleting pack source", http.StatusInternalServerError, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		h.recordActivity(env, environments.ActivityPackSync, ctx[sessions.CtxUser], "pack source deleted", before.String(), "")
+		adminOKResponse(w, "pack source deleted successfully")
+	default:
+		adminErrorResponse(w, "invalid action", http.StatusBadRequest, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Serialize and send response
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Pack sync response sent")
+	}
+	h.Inc(metricAdminOK)
+}
@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/admin/sessions"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/queries"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+// Helper to describe a query policy for the activity of the environment
+func policySummary(p queries.QueryPolicy) string {
+	if p.Subject == "" {
+		return ""
+	}
+	return fmt.Sprintf("mode: %s\ntables: %s\ncolumns: %s", p.Mode, p.Tables, p.Columns)
+}
+
+// QueryPoliciesGETHandler for GET requests for /query/{env}/policies to show the query policies of an environment
+func (h *HandlersAdmin) QueryPoliciesGETHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), false)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		h.Inc(metricAdminErr)
+		log.Println("error getting environment")
+		return
+	}
+	// Get environment
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environment: %v", err)
+		return
+	}
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Check permissions
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.QueryLevel, env.UUID) {
+		log.Printf("%s has insuficient permissions", ctx[sessions.CtxUser])
+		h.Inc(metricAdminErr)
+		return
+	}
+	policies, err := h.Queries.GetPolicies(env.ID)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting query policies: %v", err)
+		return
+	}
+	// Prepare template
+	tempateFiles := h.NewTemplateFiles(h.TemplatesFolder, "queries-policies.html").filepaths
+	t, err := template.New("queries-policies.html").ParseFiles(tempateFiles...)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting query policies template: %v", err)
+		return
+	}
+	// Get all environments
+	envAll, err := h.Envs.AllCtx(r.Context())
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environments %v", err)
+		return
+	}
+	// Get all platforms
+	platforms, err := h.Nodes.GetAllPlatforms()
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting platforms: %v", err)
+		return
+	}
+	// Prepare template data
+	templateData := QueryPoliciesTemplateData{
+		Title:        "Query policies in " + env.Name,
+		EnvName:      env.Name,
+		EnvUUID:      env.UUID,
+		Policies:     policies,
+		Tables:       h.OsqueryTables,
+		Environments: h.allowedEnvironments(ctx[sessions.CtxUser], envAll),
+		Platforms:    platforms,
+		Metadata:     h.EnvTemplateMetadata(ctx, h.ServiceVersion, env.UUID),
+		LeftMetadata: AsideLeftMetadata{EnvUUID: env.UUID},
+	}
+	if err := t.Execute(w, templateData); err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("template error %v", err)
+		return
+	}
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Query policies template served")
+	}
+	h.Inc(metricAdminOK)
+}
+
+// QueryPoliciesPOSTHandler for POST requests to set or delete the query policy of a subject
+func (h *HandlersAdmin) QueryPoliciesPOSTHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), true)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		h.Inc(metricAdminErr)
+		log.Println("error getting environment")
+		return
+	}
+	// Get environment
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environment: %v", err)
+		return
+	}
+	var p QueryPolicyRequest
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	user := ctx[sessions.CtxUser]
+	// Check permissions
+	if !h.Users.CheckPermissions(user, users.AdminLevel, env.UUID) {
+		adminErrorResponse(w, fmt.Sprintf("%s has insuficient permissions", user), http.StatusForbidden, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Parse request JSON body
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Decoding POST body")
+	}
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		adminErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Check CSRF Token
+	if !sessions.CheckCSRFToken(ctx[sessions.CtxCSRF], p.CSRFToken) {
+		adminErrorResponse(w, "invalid CSRF token", http.StatusInternalServerError, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	before, _, err := h.Queries.PolicyFor(env.ID, p.Subject)
+	if err != nil {
+		adminErrorResponse(w, "error getting query policy", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	if before.Subject != p.Subject {
+		before = queries.QueryPolicy{}
+	}
+	switch p.Action {
+	case "set":
+		policy, err := h.Queries.SetPolicy(env.ID, queries.QueryPolicy{
+			Subject: p.Subject,
+			Mode:    p.Mode,
+			Tables:  p.Tables,
+			Columns: p.Columns,
+		}, user)
+		if err != nil {
+			adminErrorResponse(w, "error setting query policy", http.StatusBadRequest, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		h.recordActivity(env, environments.ActivityPolicies, user, "query policy for "+policy.Subject+" set", policySummary(before), policySummary(policy))
+		adminOKResponse(w, "query policy for "+policy.Subject+" set successfully")
+	case "delete":
+		if err := h.Queries.DeletePolicy(env.ID, p.Subject); err != nil {
+			adminErrorResponse(w, "error deleting query policy", http.StatusNotFound, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		h.recordActivity(env, environments.ActivityPolicies, user, "query policy for "+p.Subject+" deleted", policySummary(before), "")
+		adminOKResponse(w, "query policy for "+p.Subject+" deleted successfully")
+	default:
+		adminErrorResponse(w, "invalid action", http.StatusBadRequest, fmt.Errorf("invalid action %s", p.Action))
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Serialize and send response
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Query policies response sent")
+	}
+	h.Inc(metricAdminOK)
+}
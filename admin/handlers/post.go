@@ -3,17 +3,26 @@ package handlers
 import (
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"strings"
 
 	"fmt"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/jmpsec/osctrl/admin/sessions"
+	"github.com/jmpsec/osctrl/cache"
+	"github.com/jmpsec/osctrl/carves"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/locales"
+	"github.com/jmpsec/osctrl/logging"
 	"github.com/jmpsec/osctrl/nodes"
 	"github.com/jmpsec/osctrl/queries"
 	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/tags"
+	"github.com/jmpsec/osctrl/types"
 	"github.com/jmpsec/osctrl/users"
 	"github.com/jmpsec/osctrl/utils"
 )
@@ -32,6 +41,21 @@ func (h *HandlersAdmin) LoginPOSTHandler(w http.ResponseWriter, r *http.Request)
 		h.Inc(metricAdminErr)
 		return
 	}
+	// Count the attempt before checking credentials, delayed and locked out attempts are rejected.
+	// Errors do not prevent logins.
+	subjects := users.LoginSubjects(l.Username, utils.GetIP(r))
+	if h.LoginThrottle != nil {
+		wait, err := h.LoginThrottle.Reserve(subjects)
+		if err != nil {
+			log.Printf("error checking login attempts %v", err)
+		}
+		if wait > 0 {
+			w.Header().Set("Retry-After", users.RetryAfter(wait))
+			adminErrorResponse(w, users.ErrLoginThrottled.Error(), http.StatusTooManyRequests, nil)
+			h.Inc(metricAdminErr)
+			return
+		}
+	}
 	// Check credentials
 	access, user := h.Users.CheckLoginCredentials(l.Username, l.Password)
 	if !access {
@@ -39,6 +63,11 @@ func (h *HandlersAdmin) LoginPOSTHandler(w http.ResponseWriter, r *http.Request)
 		h.Inc(metricAdminErr)
 		return
 	}
+	if h.LoginThrottle != nil {
+		if err := h.LoginThrottle.Success(subjects); err != nil {
+			log.Printf("error clearing failed logins %v", err)
+		}
+	}
 	envAccess, err := h.Users.GetEnvAccess(user.Username, user.DefaultEnv)
 	if err != nil {
 		adminErrorResponse(w, "error processing login", http.StatusInternalServerError, err)
@@ -55,7 +84,7 @@ func (h *HandlersAdmin) LoginPOSTHandler(w http.ResponseWriter, r *http.Request)
 	if h.Settings.DebugService(settings.ServiceAdmin) {
 		log.Println("DebugService: Login response sent")
 	}
-	adminOKResponse(w, "/environment/"+user.DefaultEnv+"/active")
+	adminOKResponse(w, h.URLPrefix+"/environment/"+user.DefaultEnv+"/active")
 	h.Inc(metricAdminOK)
 }
 
@@ -108,7 +137,7 @@ func (h *HandlersAdmin) QueryRunPOSTHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 	// Get environment
-	env, err := h.Envs.Get(envVar)
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
 	if err != nil {
 		log.Printf("error getting environment %s - %v", envVar, err)
 		h.Inc(metricAdminErr)
@@ -138,21 +167,157 @@ func (h *HandlersAdmin) QueryRunPOSTHandler(w http.ResponseWriter, r *http.Reque
 		h.Inc(metricAdminErr)
 		return
 	}
+	// Critical notices of the environment must be confirmed
+	if err := h.Users.CheckConfirmation(env.UUID, q.ConfirmNotices); err != nil {
+		adminErrorResponse(w, err.Error(), http.StatusConflict, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Launches over many targets need the name of the environment typed, by the policy of its category
+	if err := h.checkLaunch(env, q.Environments, q.Platforms, q.UUIDs, q.Hosts, q.ConfirmName); errors.Is(err, environments.ErrConfirmName) {
+		adminErrorResponse(w, err.Error(), http.StatusPreconditionRequired, nil)
+		h.Inc(metricAdminErr)
+		return
+	} else if err != nil {
+		adminErrorResponse(w, "error getting targets", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Query and template are exclusive
+	if q.Query != "" && q.Template != "" {
+		adminErrorResponse(w, "query and template can not be used together", http.StatusBadRequest, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
 	// FIXME check validity of query
 	// Query can not be empty
-	if q.Query == "" {
+	if q.Query == "" && q.Template == "" {
 		adminErrorResponse(w, "query can not be empty", http.StatusInternalServerError, nil)
 		h.Inc(metricAdminErr)
 		return
 	}
+	// Investigation context is checked before the query is created
+	labels, err := queries.ParseLabels(q.Labels)
+	if err != nil {
+		adminErrorResponse(w, "invalid labels", http.StatusBadRequest, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	queryContext := queries.QueryContext{
+		Description: q.Description,
+		TicketID:    q.TicketID,
+		TicketURL:   q.TicketURL,
+		Labels:      labels,
+	}
+	if err := queries.ValidateContext(queryContext); err != nil {
+		adminErrorResponse(w, "invalid query context", http.StatusBadRequest, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	window, err := queries.ParseTargetWindow(q.EnrolledAfter, q.EnrolledBefore, q.SeenAfter, q.SeenBefore)
+	if err != nil {
+		adminErrorResponse(w, "invalid time window", http.StatusBadRequest, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	attrFilters, err := queries.ParseAttributeTargets(q.Attributes)
+	if err != nil {
+		adminErrorResponse(w, "invalid attributes target", http.StatusBadRequest, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	if err := queries.ValidateGroup(q.Group); err != nil {
+		adminErrorResponse(w, "invalid query group", http.StatusBadRequest, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	expiresAt, err := h.queryExpiration(env).ExpiresAt(q.Expiration, time.Now())
+	if err != nil {
+		adminErrorResponse(w, "invalid expiration", http.StatusBadRequest, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	if err := queries.ValidateVisibility(q.Visibility, q.VisibilityTeam); err != nil {
+		adminErrorResponse(w, "invalid visibility", http.StatusBadRequest, err)
+		h.Inc(metricAdminErr)
+		return
+	}
 	// FIXME check if query is carve and user has permissions to carve
 	// Prepare and create new query
 	newQuery := newQueryReady(ctx[sessions.CtxUser], q.Query, env.ID)
+	newQuery.Visibility = q.Visibility
+	newQuery.VisibilityTeam = q.VisibilityTeam
+	newQuery.Group = q.Group
+	newQuery.Priority = q.Priority
+	newQuery.ExpiresAt = expiresAt
+	var usedTemplate *queries.QueryTemplate
+	if q.Template != "" {
+		tpl, err := h.Queries.GetTemplate(q.Template)
+		if err != nil {
+			adminErrorResponse(w, "error getting template", http.StatusBadRequest, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		usedTemplate = &tpl
+		for _, p := range q.Platforms {
+			if p != "" && !tpl.SupportsPlatform(p) {
+				adminErrorResponse(w, fmt.Sprintf("template %s does not support platform %s", tpl.Name, p), http.StatusBadRequest, nil)
+				h.Inc(metricAdminErr)
+				return
+			}
+		}
+		if err := queries.ApplyTemplate(&newQuery, tpl, q.Variables); err != nil {
+			adminErrorResponse(w, "error rendering template", http.StatusBadRequest, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+	}
+	// Query policy of the creator, admins can override it with a justification
+	overridden := h.Queries.CheckPolicy(env.ID, ctx[sessions.CtxUser], newQuery.Query, usedTemplate)
+	if overridden != nil {
+		if !q.Override {
+			adminErrorResponse(w, overridden.Error(), http.StatusForbidden, nil)
+			h.Inc(metricAdminErr)
+			return
+		}
+		if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.AdminLevel, env.UUID) {
+			adminErrorResponse(w, "only admins can override query policies", http.StatusForbidden, overridden)
+			h.Inc(metricAdminErr)
+			return
+		}
+		if strings.TrimSpace(q.Justification) == "" {
+			adminErrorResponse(w, "justification is required to override query policies", http.StatusBadRequest, nil)
+			h.Inc(metricAdminErr)
+			return
+		}
+	}
+	if q.Profile {
+		if newQuery.Type == queries.CarveQueryType {
+			adminErrorResponse(w, "carves can not be profiled", http.StatusBadRequest, nil)
+			h.Inc(metricAdminErr)
+			return
+		}
+		newQuery.Type = queries.ProfileQueryType
+		newQuery.SampleSize = q.Sample
+		if newQuery.SampleSize <= 0 {
+			newQuery.SampleSize = queries.DefaultProfileSample
+		}
+	}
 	if err := h.Queries.Create(newQuery); err != nil {
 		adminErrorResponse(w, "error creating query", http.StatusInternalServerError, err)
 		h.Inc(metricAdminErr)
 		return
 	}
+	if overridden != nil {
+		h.recordActivity(env, environments.ActivityPolicies, ctx[sessions.CtxUser], fmt.Sprintf("query %s run overriding policy (%v): %s", newQuery.Name, overridden, q.Justification), "", newQuery.Query)
+	}
+	if !queryContext.Empty() {
+		if _, err := h.Queries.SetContext(newQuery.Name, env.ID, queryContext); err != nil {
+			adminErrorResponse(w, "error setting query context", http.StatusInternalServerError, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+	}
 	// Temporary list of UUIDs to calculate Expected
 	var expected []string
 	// Create environment target
@@ -224,10 +389,38 @@ func (h *HandlersAdmin) QueryRunPOSTHandler(w http.ResponseWriter, r *http.Reque
 			}
 		}
 	}
-	// Remove duplicates from expected
-	expectedClear := removeStringDuplicates(expected)
+	// Create custom attributes targets
+	attrTargets, err := h.Queries.CreateAttributeTargets(newQuery.Name, attrFilters)
+	if err != nil {
+		adminErrorResponse(w, "error creating query attributes target", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Create time window targets
+	if err := h.Queries.CreateWindowTargets(newQuery.Name, window); err != nil {
+		adminErrorResponse(w, "error creating query time window targets", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Materialize the query for the targeted nodes
+	targeted, err := h.Queries.Materialize(newQuery.Name, env.ID)
+	if err != nil {
+		adminErrorResponse(w, "error materializing query", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Remove duplicates from expected, nodes within a time window or by attributes are the ones materialized
+	expectedClear := len(removeStringDuplicates(expected))
+	if !window.Empty() || attrTargets > 0 {
+		expectedClear = targeted
+	}
 	// Update value for expected
-	if err := h.Queries.SetExpected(newQuery.Name, len(expectedClear), env.ID); err != nil {
+	if q.Profile {
+		err = h.Queries.SetProfileTargets(newQuery.Name, expectedClear, env.ID)
+	} else {
+		err = h.Queries.SetExpected(newQuery.Name, expectedClear, env.ID)
+	}
+	if err != nil {
 		adminErrorResponse(w, "error setting expected", http.StatusInternalServerError, err)
 		h.Inc(metricAdminErr)
 		return
@@ -244,7 +437,80 @@ func (h *HandlersAdmin) QueryRunPOSTHandler(w http.ResponseWriter, r *http.Reque
 	if h.Settings.DebugService(settings.ServiceAdmin) {
 		log.Println("DebugService: Query run response sent")
 	}
-	adminOKResponse(w, "OK")
+	h.publishEvent(cache.EventQuery, env.UUID, newQuery.Name)
+	if q.Profile {
+		// Name is needed to redirect to the profile report
+		adminOKResponse(w, newQuery.Name)
+	} else {
+		adminOKResponse(w, "OK")
+	}
+	h.Inc(metricAdminOK)
+}
+
+// QueryProfileLaunchPOSTHandler for POST requests to launch the real query from a profile query
+func (h *HandlersAdmin) QueryProfileLaunchPOSTHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), true)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		log.Println("environment is missing")
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Get environment
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		log.Printf("error getting environment %s - %v", envVar, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Extract name
+	name, ok := vars["name"]
+	if !ok {
+		log.Println("name is missing")
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Check permissions for query
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.QueryLevel, env.UUID) {
+		adminErrorResponse(w, fmt.Sprintf("%s has insuficient permissions", ctx[sessions.CtxUser]), http.StatusForbidden, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	var p ProfileLaunchRequest
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		adminErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Check CSRF Token
+	if !sessions.CheckCSRFToken(ctx[sessions.CtxCSRF], p.CSRFToken) {
+		adminErrorResponse(w, "invalid CSRF token", http.StatusInternalServerError, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Only who can see the profile can launch it, the launched query has the same visibility
+	if !h.canViewName(r.Context(), name, env.ID, ctx[sessions.CtxUser]) {
+		adminErrorResponse(w, "query results are restricted", http.StatusForbidden, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	newQuery, err := h.Queries.LaunchProfile(name, env.ID, ctx[sessions.CtxUser])
+	if err != nil {
+		adminErrorResponse(w, "error launching query", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	h.publishEvent(cache.EventQuery, env.UUID, newQuery.Name)
+	// Serialize and send response
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Printf("DebugService: Query %s launched from profile %s", newQuery.Name, name)
+	}
+	adminOKResponse(w, newQuery.Name)
 	h.Inc(metricAdminOK)
 }
 
@@ -261,7 +527,7 @@ func (h *HandlersAdmin) CarvesRunPOSTHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 	// Get environment
-	env, err := h.Envs.Get(envVar)
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
 	if err != nil {
 		log.Printf("error getting environment %s - %v", envVar, err)
 		h.Inc(metricAdminErr)
@@ -291,6 +557,22 @@ func (h *HandlersAdmin) CarvesRunPOSTHandler(w http.ResponseWriter, r *http.Requ
 		h.Inc(metricAdminErr)
 		return
 	}
+	// Critical notices of the environment must be confirmed
+	if err := h.Users.CheckConfirmation(env.UUID, c.ConfirmNotices); err != nil {
+		adminErrorResponse(w, err.Error(), http.StatusConflict, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Launches over many targets need the name of the environment typed, by the policy of its category
+	if err := h.checkLaunch(env, c.Environments, c.Platforms, c.UUIDs, c.Hosts, c.ConfirmName); errors.Is(err, environments.ErrConfirmName) {
+		adminErrorResponse(w, err.Error(), http.StatusPreconditionRequired, nil)
+		h.Inc(metricAdminErr)
+		return
+	} else if err != nil {
+		adminErrorResponse(w, "error getting targets", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
 	// FIXME check validity of query
 	// Path can not be empty
 	if c.Path == "" {
@@ -298,27 +580,74 @@ func (h *HandlersAdmin) CarvesRunPOSTHandler(w http.ResponseWriter, r *http.Requ
 		h.Inc(metricAdminErr)
 		return
 	}
+	// New carves are blocked while the storage quota of the environment is exceeded
+	quota, err := h.Envs.GetStorageQuota(env.ID)
+	if err != nil {
+		adminErrorResponse(w, "error getting storage quota", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	if quota.Active(environments.QuotaBlockCarves) {
+		adminErrorResponse(w, "storage quota exceeded, new carves are blocked", http.StatusInsufficientStorage, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Denied paths are only carved by admins with a justification
+	override, pattern, err := h.carvePathAllowed(env, ctx[sessions.CtxUser], c.Path, c.Justification)
+	if err != nil {
+		adminErrorResponse(w, err.Error(), http.StatusForbidden, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	window, err := queries.ParseTargetWindow(c.EnrolledAfter, c.EnrolledBefore, c.SeenAfter, c.SeenBefore)
+	if err != nil {
+		adminErrorResponse(w, "invalid time window", http.StatusBadRequest, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	attrFilters, err := queries.ParseAttributeTargets(c.Attributes)
+	if err != nil {
+		adminErrorResponse(w, "invalid attributes target", http.StatusBadRequest, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	if err := queries.ValidateVisibility(c.Visibility, c.VisibilityTeam); err != nil {
+		adminErrorResponse(w, "invalid visibility", http.StatusBadRequest, err)
+		h.Inc(metricAdminErr)
+		return
+	}
 	query := generateCarveQuery(c.Path, false)
 	// Prepare and create new carve
 	carveName := generateCarveName()
 	newQuery := queries.DistributedQuery{
-		Query:         query,
-		Name:          carveName,
-		Creator:       ctx[sessions.CtxUser],
-		Expected:      0,
-		Executions:    0,
-		Active:        true,
-		Completed:     false,
-		Deleted:       false,
-		Type:          queries.CarveQueryType,
-		Path:          c.Path,
-		EnvironmentID: env.ID,
+		Query:          query,
+		Name:           carveName,
+		Creator:        ctx[sessions.CtxUser],
+		Expected:       0,
+		Executions:     0,
+		Active:         true,
+		Completed:      false,
+		Deleted:        false,
+		Type:           queries.CarveQueryType,
+		Path:           c.Path,
+		EnvironmentID:  env.ID,
+		Visibility:     c.Visibility,
+		VisibilityTeam: c.VisibilityTeam,
 	}
 	if err := h.Queries.Create(newQuery); err != nil {
 		adminErrorResponse(w, "error creating carve", http.StatusInternalServerError, err)
 		h.Inc(metricAdminErr)
 		return
 	}
+	if override != nil {
+		override.QueryName = carveName
+		if err := h.Carves.AddPathOverride(*override); err != nil {
+			adminErrorResponse(w, "error allowing carve of denied path", http.StatusInternalServerError, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		h.recordActivity(env, environments.ActivityCarves, ctx[sessions.CtxUser], carves.PathOverrideSummary(*override, pattern), "", "")
+	}
 	// Temporary list of UUIDs to calculate Expected
 	var expected []string
 	// Create environment target
@@ -389,14 +718,38 @@ func (h *HandlersAdmin) CarvesRunPOSTHandler(w http.ResponseWriter, r *http.Requ
 			}
 		}
 	}
-	// Remove duplicates from expected
-	expectedClear := removeStringDuplicates(expected)
+	// Create custom attributes targets
+	attrTargets, err := h.Queries.CreateAttributeTargets(carveName, attrFilters)
+	if err != nil {
+		adminErrorResponse(w, "error creating carve attributes target", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Create time window targets
+	if err := h.Queries.CreateWindowTargets(carveName, window); err != nil {
+		adminErrorResponse(w, "error creating carve time window targets", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Materialize the carve for the targeted nodes
+	targeted, err := h.Queries.Materialize(carveName, env.ID)
+	if err != nil {
+		adminErrorResponse(w, "error materializing carve", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Remove duplicates from expected, nodes within a time window or by attributes are the ones materialized
+	expectedClear := len(removeStringDuplicates(expected))
+	if !window.Empty() || attrTargets > 0 {
+		expectedClear = targeted
+	}
 	// Update value for expected
-	if err := h.Queries.SetExpected(carveName, len(expectedClear), env.ID); err != nil {
+	if err := h.Queries.SetExpected(carveName, expectedClear, env.ID); err != nil {
 		adminErrorResponse(w, "error setting expected", http.StatusInternalServerError, err)
 		h.Inc(metricAdminErr)
 		return
 	}
+	h.publishEvent(cache.EventQuery, env.UUID, carveName)
 	// Serialize and send response
 	if h.Settings.DebugService(settings.ServiceAdmin) {
 		log.Println("DebugService: Carve run response sent")
@@ -418,7 +771,7 @@ func (h *HandlersAdmin) QueryActionsPOSTHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 	// Get environment
-	env, err := h.Envs.Get(envVar)
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
 	if err != nil {
 		log.Printf("error getting environment %s - %v", envVar, err)
 		h.Inc(metricAdminErr)
@@ -451,12 +804,13 @@ func (h *HandlersAdmin) QueryActionsPOSTHandler(w http.ResponseWriter, r *http.R
 	switch q.Action {
 	case "delete":
 		for _, n := range q.Names {
-			if err := h.Queries.Delete(n, env.ID); err != nil {
+			if err := h.Queries.Delete(n, env.ID, ctx[sessions.CtxUser]); err != nil {
 				adminErrorResponse(w, "error deleting query", http.StatusInternalServerError, err)
 				h.Inc(metricAdminErr)
 				return
 			}
 		}
+		h.publishEvent(cache.EventQuery, env.UUID, strings.Join(q.Names, ","))
 		adminOKResponse(w, "queries delete successfully")
 	case "complete":
 		for _, n := range q.Names {
@@ -466,6 +820,7 @@ func (h *HandlersAdmin) QueryActionsPOSTHandler(w http.ResponseWriter, r *http.R
 				return
 			}
 		}
+		h.publishEvent(cache.EventQuery, env.UUID, strings.Join(q.Names, ","))
 		adminOKResponse(w, "queries completed successfully")
 	case "activate":
 		for _, n := range q.Names {
@@ -475,6 +830,7 @@ func (h *HandlersAdmin) QueryActionsPOSTHandler(w http.ResponseWriter, r *http.R
 				return
 			}
 		}
+		h.publishEvent(cache.EventQuery, env.UUID, strings.Join(q.Names, ","))
 		adminOKResponse(w, "queries activated successfully")
 	case "saved_delete":
 		for _, n := range q.Names {
@@ -493,6 +849,88 @@ func (h *HandlersAdmin) QueryActionsPOSTHandler(w http.ResponseWriter, r *http.R
 	h.Inc(metricAdminOK)
 }
 
+// QueryContextPOSTHandler for POST requests to change the investigation context of a query,
+// only the creator of the query and admins can change it
+func (h *HandlersAdmin) QueryContextPOSTHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), true)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		log.Println("environment is missing")
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Get environment
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		log.Printf("error getting environment %s - %v", envVar, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Check permissions for query
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.QueryLevel, env.UUID) {
+		adminErrorResponse(w, fmt.Sprintf("%s has insuficient permissions", ctx[sessions.CtxUser]), http.StatusForbidden, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Parse request JSON body
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Decoding POST body")
+	}
+	var c QueryContextRequest
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		adminErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Check CSRF Token
+	if !sessions.CheckCSRFToken(ctx[sessions.CtxCSRF], c.CSRFToken) {
+		adminErrorResponse(w, "invalid CSRF token", http.StatusInternalServerError, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	query, err := h.Queries.GetCtx(r.Context(), c.Name, env.ID)
+	if err != nil || query.ID == 0 {
+		adminErrorResponse(w, "error getting query", http.StatusNotFound, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	if query.Creator != ctx[sessions.CtxUser] && !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.AdminLevel, env.UUID) {
+		adminErrorResponse(w, fmt.Sprintf("%s can not change the context of query %s", ctx[sessions.CtxUser], query.Name), http.StatusForbidden, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	labels, err := queries.ParseLabels(c.Labels)
+	if err != nil {
+		adminErrorResponse(w, "invalid labels", http.StatusBadRequest, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	after := queries.QueryContext{
+		Description: c.Description,
+		TicketID:    c.TicketID,
+		TicketURL:   c.TicketURL,
+		Labels:      labels,
+	}
+	before, err := h.Queries.SetContext(query.Name, env.ID, after)
+	if err != nil {
+		adminErrorResponse(w, "error setting query context", http.StatusBadRequest, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	h.recordActivity(env, environments.ActivityQueries, ctx[sessions.CtxUser], "context of query "+query.Name+" changed", queries.SerializeContext(before), queries.SerializeContext(after))
+	// Serialize and send response
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Query context response sent")
+	}
+	adminOKResponse(w, "query context saved successfully")
+	h.Inc(metricAdminOK)
+}
+
 // CarvesActionsPOSTHandler - Handler for POST requests to carves
 func (h *HandlersAdmin) CarvesActionsPOSTHandler(w http.ResponseWriter, r *http.Request) {
 	h.Inc(metricAdminReq)
@@ -531,10 +969,46 @@ func (h *HandlersAdmin) CarvesActionsPOSTHandler(w http.ResponseWriter, r *http.
 			}
 		}
 		adminOKResponse(w, "carves delete successfully")
-	case "test":
-		if h.Settings.DebugService(settings.ServiceAdmin) {
-			log.Printf("DebugService: testing action")
-		}
+	case "scan":
+		scanner := carves.ScannerFromSettings(h.Settings)
+		if scanner == nil {
+			adminErrorResponse(w, "no carve scanner configured", http.StatusBadRequest, nil)
+			h.Inc(metricAdminErr)
+			return
+		}
+		for _, n := range q.IDs {
+			carve, err := h.Carves.GetByCarve(n)
+			if err != nil {
+				adminErrorResponse(w, "error getting carve", http.StatusInternalServerError, err)
+				h.Inc(metricAdminErr)
+				return
+			}
+			if err := h.Carves.StartScan(scanner, carve.SessionID); err != nil {
+				adminErrorResponse(w, "error scanning carve", http.StatusBadRequest, err)
+				h.Inc(metricAdminErr)
+				return
+			}
+		}
+		adminOKResponse(w, "carves scan started successfully")
+	case "index":
+		for _, n := range q.IDs {
+			carve, err := h.Carves.GetByCarve(n)
+			if err != nil {
+				adminErrorResponse(w, "error getting carve", http.StatusInternalServerError, err)
+				h.Inc(metricAdminErr)
+				return
+			}
+			if err := h.Carves.StartManifest(carve.SessionID); err != nil {
+				adminErrorResponse(w, "error indexing carve", http.StatusBadRequest, err)
+				h.Inc(metricAdminErr)
+				return
+			}
+		}
+		adminOKResponse(w, "carves indexing started successfully")
+	case "test":
+		if h.Settings.DebugService(settings.ServiceAdmin) {
+			log.Printf("DebugService: testing action")
+		}
 		adminOKResponse(w, "test successful")
 	}
 	// Serialize and send response
@@ -557,7 +1031,7 @@ func (h *HandlersAdmin) ConfPOSTHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	// Get environment
-	env, err := h.Envs.Get(envVar)
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
 	if err != nil {
 		h.Inc(metricAdminErr)
 		log.Printf("error getting environment: %v", err)
@@ -587,6 +1061,17 @@ func (h *HandlersAdmin) ConfPOSTHandler(w http.ResponseWriter, r *http.Request)
 		h.Inc(metricAdminErr)
 		return
 	}
+	// Changes need a note when the policy of the category of the environment asks for it
+	if err := h.categoryPolicy(env).CheckNote(c.Note); err != nil {
+		adminErrorResponse(w, err.Error(), http.StatusBadRequest, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Changes with an apply time are scheduled for a maintenance window instead of saved
+	if !c.ApplyAt.IsZero() {
+		h.scheduleConfigChange(w, env, c, ctx[sessions.CtxUser])
+		return
+	}
 	if c.ConfigurationB64 != "" {
 		// Base64 decode received configuration
 		// TODO verify configuration
@@ -603,6 +1088,13 @@ func (h *HandlersAdmin) ConfPOSTHandler(w http.ResponseWriter, r *http.Request)
 			h.Inc(metricAdminErr)
 			return
 		}
+		// Check conflicts before saving
+		warnings, err := h.configConflicts(env, environments.ActivityConfiguration, string(configuration))
+		if err != nil {
+			adminErrorResponse(w, err.Error(), http.StatusBadRequest, nil)
+			h.Inc(metricAdminErr)
+			return
+		}
 		// Update configuration
 		if err := h.Envs.UpdateConfiguration(env.UUID, cnf); err != nil {
 			adminErrorResponse(w, "error saving configuration", http.StatusInternalServerError, err)
@@ -615,11 +1107,12 @@ func (h *HandlersAdmin) ConfPOSTHandler(w http.ResponseWriter, r *http.Request)
 			h.Inc(metricAdminErr)
 			return
 		}
+		h.recordConfigChange(env, environments.ActivityConfiguration, string(configuration), ctx[sessions.CtxUser], withNote("configuration updated", c.Note))
 		// Send response
 		if h.Settings.DebugService(settings.ServiceAdmin) {
 			log.Println("DebugService: Configuration response sent")
 		}
-		adminOKResponse(w, "configuration saved successfully")
+		adminOKResponse(w, conflictsMessage("configuration saved successfully", warnings))
 		h.Inc(metricAdminOK)
 		return
 	}
@@ -632,6 +1125,13 @@ func (h *HandlersAdmin) ConfPOSTHandler(w http.ResponseWriter, r *http.Request)
 			h.Inc(metricAdminErr)
 			return
 		}
+		// Check conflicts before saving
+		warnings, err := h.configConflicts(env, environments.ActivityOptions, string(options))
+		if err != nil {
+			adminErrorResponse(w, err.Error(), http.StatusBadRequest, nil)
+			h.Inc(metricAdminErr)
+			return
+		}
 		// Update options
 		if err := h.Envs.UpdateOptions(env.UUID, string(options)); err != nil {
 			adminErrorResponse(w, "error saving options", http.StatusInternalServerError, err)
@@ -644,11 +1144,12 @@ func (h *HandlersAdmin) ConfPOSTHandler(w http.ResponseWriter, r *http.Request)
 			h.Inc(metricAdminErr)
 			return
 		}
+		h.recordConfigChange(env, environments.ActivityOptions, string(options), ctx[sessions.CtxUser], withNote("options updated", c.Note))
 		// Send response
 		if h.Settings.DebugService(settings.ServiceAdmin) {
 			log.Println("DebugService: Options response sent")
 		}
-		adminOKResponse(w, "options saved successfully")
+		adminOKResponse(w, conflictsMessage("options saved successfully", warnings))
 		h.Inc(metricAdminOK)
 		return
 	}
@@ -661,6 +1162,13 @@ func (h *HandlersAdmin) ConfPOSTHandler(w http.ResponseWriter, r *http.Request)
 			h.Inc(metricAdminErr)
 			return
 		}
+		// Check conflicts before saving
+		warnings, err := h.configConflicts(env, environments.ActivitySchedule, string(schedule))
+		if err != nil {
+			adminErrorResponse(w, err.Error(), http.StatusBadRequest, nil)
+			h.Inc(metricAdminErr)
+			return
+		}
 		// Update schedule
 		if err := h.Envs.UpdateSchedule(env.UUID, string(schedule)); err != nil {
 			adminErrorResponse(w, "error saving schedule", http.StatusInternalServerError, err)
@@ -673,11 +1181,12 @@ func (h *HandlersAdmin) ConfPOSTHandler(w http.ResponseWriter, r *http.Request)
 			h.Inc(metricAdminErr)
 			return
 		}
+		h.recordConfigChange(env, environments.ActivitySchedule, string(schedule), ctx[sessions.CtxUser], withNote("schedule updated", c.Note))
 		// Send response
 		if h.Settings.DebugService(settings.ServiceAdmin) {
 			log.Println("DebugService: Schedule response sent")
 		}
-		adminOKResponse(w, "schedule saved successfully")
+		adminOKResponse(w, conflictsMessage("schedule saved successfully", warnings))
 		h.Inc(metricAdminOK)
 		return
 	}
@@ -690,6 +1199,13 @@ func (h *HandlersAdmin) ConfPOSTHandler(w http.ResponseWriter, r *http.Request)
 			h.Inc(metricAdminErr)
 			return
 		}
+		// Check conflicts before saving
+		warnings, err := h.configConflicts(env, environments.ActivityPacks, string(packs))
+		if err != nil {
+			adminErrorResponse(w, err.Error(), http.StatusBadRequest, nil)
+			h.Inc(metricAdminErr)
+			return
+		}
 		// Update packs
 		if err := h.Envs.UpdatePacks(env.UUID, string(packs)); err != nil {
 			adminErrorResponse(w, "error saving packs", http.StatusInternalServerError, err)
@@ -702,11 +1218,12 @@ func (h *HandlersAdmin) ConfPOSTHandler(w http.ResponseWriter, r *http.Request)
 			h.Inc(metricAdminErr)
 			return
 		}
+		h.recordConfigChange(env, environments.ActivityPacks, string(packs), ctx[sessions.CtxUser], withNote("packs updated", c.Note))
 		// Send response
 		if h.Settings.DebugService(settings.ServiceAdmin) {
 			log.Println("DebugService: Packs response sent")
 		}
-		adminOKResponse(w, "packs saved successfully")
+		adminOKResponse(w, conflictsMessage("packs saved successfully", warnings))
 		h.Inc(metricAdminOK)
 		return
 	}
@@ -731,6 +1248,7 @@ func (h *HandlersAdmin) ConfPOSTHandler(w http.ResponseWriter, r *http.Request)
 			h.Inc(metricAdminErr)
 			return
 		}
+		h.recordConfigChange(env, environments.ActivityDecorators, string(decorators), ctx[sessions.CtxUser], withNote("decorators updated", c.Note))
 		// Send response
 		if h.Settings.DebugService(settings.ServiceAdmin) {
 			log.Println("DebugService: Decorators response sent")
@@ -760,6 +1278,7 @@ func (h *HandlersAdmin) ConfPOSTHandler(w http.ResponseWriter, r *http.Request)
 			h.Inc(metricAdminErr)
 			return
 		}
+		h.recordConfigChange(env, environments.ActivityATC, string(schedule), ctx[sessions.CtxUser], withNote("ATC updated", c.Note))
 		// Send response
 		if h.Settings.DebugService(settings.ServiceAdmin) {
 			log.Println("DebugService: ATC response sent")
@@ -791,7 +1310,7 @@ func (h *HandlersAdmin) IntervalsPOSTHandler(w http.ResponseWriter, r *http.Requ
 	}
 	// TODO do the exist and get in one step
 	// Get environment
-	env, err := h.Envs.Get(envVar)
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
 	if err != nil {
 		adminErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
 		h.Inc(metricAdminErr)
@@ -839,6 +1358,8 @@ func (h *HandlersAdmin) IntervalsPOSTHandler(w http.ResponseWriter, r *http.Requ
 		h.Inc(metricAdminErr)
 		return
 	}
+	h.recordChange(env, environments.ActivityIntervals, ctx[sessions.CtxUser], "intervals updated")
+	h.recordChange(env, environments.ActivityFlags, ctx[sessions.CtxUser], "flags generated for new intervals")
 	// Serialize and send response
 	if h.Settings.DebugService(settings.ServiceAdmin) {
 		log.Println("DebugService: Intervals response sent")
@@ -860,7 +1381,7 @@ func (h *HandlersAdmin) ExpirationPOSTHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 	// Get environment
-	env, err := h.Envs.Get(envVar)
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
 	if err != nil {
 		h.Inc(metricAdminErr)
 		log.Printf("error getting environment: %v", err)
@@ -954,6 +1475,9 @@ func (h *HandlersAdmin) ExpirationPOSTHandler(w http.ResponseWriter, r *http.Req
 			adminOKResponse(w, "link set to not expire successfully")
 		}
 	}
+	if done, ok := linkActivity[e.Action]; ok && (e.Type == settings.ScriptEnroll || e.Type == settings.ScriptRemove) {
+		h.recordChange(env, e.Type, ctx[sessions.CtxUser], fmt.Sprintf("%s link %s", e.Type, done))
+	}
 	// Serialize and send response
 	if h.Settings.DebugService(settings.ServiceAdmin) {
 		log.Println("DebugService: Expiration response sent")
@@ -989,12 +1513,14 @@ func (h *HandlersAdmin) NodeActionsPOSTHandler(w http.ResponseWriter, r *http.Re
 		h.Inc(metricAdminErr)
 		return
 	}
+	// Cached node lists of the API are refreshed, even if some nodes fail
+	defer h.invalidateResponses(cache.ResponseGroupNodes)
 	switch m.Action {
 	case "delete":
 		okCount := 0
 		errCount := 0
 		for _, u := range m.UUIDs {
-			if err := h.Nodes.ArchiveDeleteByUUID(u); err != nil {
+			if err := h.Nodes.ArchiveDeleteByUUID(u, ctx[sessions.CtxUser]); err != nil {
 				errCount++
 				if h.Settings.DebugService(settings.ServiceAdmin) {
 					log.Printf("DebugService: error deleting node %s %v", u, err)
@@ -1010,6 +1536,61 @@ func (h *HandlersAdmin) NodeActionsPOSTHandler(w http.ResponseWriter, r *http.Re
 			h.Inc(metricAdminErr)
 			return
 		}
+	case "decommission":
+		okCount := 0
+		errCount := 0
+		for _, u := range m.UUIDs {
+			if _, err := h.Nodes.Decommission(u, ctx[sessions.CtxUser]); err != nil {
+				errCount++
+				if h.Settings.DebugService(settings.ServiceAdmin) {
+					log.Printf("DebugService: error decommissioning node %s %v", u, err)
+				}
+			} else {
+				okCount++
+			}
+		}
+		if errCount == 0 {
+			adminOKResponse(w, fmt.Sprintf("%d Node(s) are being decommissioned", okCount))
+		} else {
+			adminErrorResponse(w, fmt.Sprintf("Error decommissioning %d node(s)", errCount), http.StatusInternalServerError, nil)
+			h.Inc(metricAdminErr)
+			return
+		}
+	case "pause", "unpause":
+		expires, err := users.ParseNoticeExpiry(m.Expires, time.Now())
+		if err != nil {
+			adminErrorResponse(w, "invalid expiration", http.StatusBadRequest, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		okCount := 0
+		errCount := 0
+		for _, u := range m.UUIDs {
+			var p nodes.NodePause
+			if m.Action == "pause" {
+				p, err = h.Nodes.Pause(u, m.Reason, ctx[sessions.CtxUser], expires)
+			} else {
+				p, err = h.Nodes.Unpause(u, ctx[sessions.CtxUser])
+			}
+			if err != nil {
+				errCount++
+				if h.Settings.DebugService(settings.ServiceAdmin) {
+					log.Printf("DebugService: error with %s of node %s %v", m.Action, u, err)
+				}
+				continue
+			}
+			okCount++
+			if err := h.Envs.RecordActivity(p.EnvironmentID, environments.ActivityPauses, ctx[sessions.CtxUser], nodes.PauseSummary(p), "", ""); err != nil {
+				log.Printf("error recording activity for %s of node %s %v", m.Action, u, err)
+			}
+		}
+		if errCount == 0 {
+			adminOKResponse(w, fmt.Sprintf("%d Node(s) have been %sd successfully", okCount, m.Action))
+		} else {
+			adminErrorResponse(w, fmt.Sprintf("Error with %s of %d node(s)", m.Action, errCount), http.StatusInternalServerError, nil)
+			h.Inc(metricAdminErr)
+			return
+		}
 	}
 	// Serialize and send response
 	if h.Settings.DebugService(settings.ServiceAdmin) {
@@ -1049,25 +1630,26 @@ func (h *HandlersAdmin) EnvsPOSTHandler(w http.ResponseWriter, r *http.Request)
 	switch c.Action {
 	case "create":
 		// FIXME verify fields
+		if !environments.IsCategory(c.Category) {
+			adminErrorResponse(w, "invalid category", http.StatusBadRequest, fmt.Errorf("category %s", c.Category))
+			h.Inc(metricAdminErr)
+			return
+		}
 		if !h.Envs.Exists(c.Name) && c.Name != "" {
-			env := h.Envs.Empty(c.Name, c.Hostname)
-			env.Icon = c.Icon
-			env.Type = c.Type
-			// Emtpy configuration
-			env.Configuration = h.Envs.GenEmptyConfiguration(true)
-			// Generate flags
-			flags, err := h.Envs.GenerateFlags(env, "", "")
+			env, err := h.createEnvironment(c.Name, c.Hostname, c.Template, c.Icon, c.Type, ctx[sessions.CtxUser])
 			if err != nil {
-				adminErrorResponse(w, "error generating flags", http.StatusInternalServerError, err)
-				h.Inc(metricAdminErr)
-				return
-			}
-			env.Flags = flags
-			if err := h.Envs.Create(env); err != nil {
 				adminErrorResponse(w, "error creating environment", http.StatusInternalServerError, err)
 				h.Inc(metricAdminErr)
 				return
 			}
+			if c.Category != "" {
+				if err := h.Envs.UpdateCategory(env.Name, c.Category); err != nil {
+					adminErrorResponse(w, "error setting category", http.StatusInternalServerError, err)
+					h.Inc(metricAdminErr)
+					return
+				}
+				h.recordChange(env, environments.ActivityCategory, ctx[sessions.CtxUser], fmt.Sprintf("category set to %s", c.Category))
+			}
 			// Generate full permissions for the user creating the environment
 			access := h.Users.GenEnvUserAccess([]string{env.UUID}, true, true, true, true)
 			perms := h.Users.GenPermissions(ctx[sessions.CtxUser], "osctrl-admin", access)
@@ -1076,12 +1658,7 @@ func (h *HandlersAdmin) EnvsPOSTHandler(w http.ResponseWriter, r *http.Request)
 				h.Inc(metricAdminErr)
 				return
 			}
-			// Create a tag for this new environment
-			if err := h.Tags.NewTag(env.Name, "Tag for environment "+env.Name, "", env.Icon, ctx[sessions.CtxUser]); err != nil {
-				adminErrorResponse(w, "error generating tag", http.StatusInternalServerError, err)
-				h.Inc(metricAdminErr)
-				return
-			}
+			h.publishEvent(cache.EventEnvironment, env.UUID, "create")
 			adminOKResponse(w, "environment created successfully")
 		} else {
 			adminOKResponse(w, "invalid environment")
@@ -1101,17 +1678,271 @@ func (h *HandlersAdmin) EnvsPOSTHandler(w http.ResponseWriter, r *http.Request)
 				return
 			}
 		}
+		h.publishEvent(cache.EventEnvironment, "", "delete")
 		adminOKResponse(w, "environment deleted successfully")
 	case "debug":
 		// FIXME verify fields
-		if h.Envs.Exists(c.Name) {
+		if env, err := h.Envs.Get(c.Name); err == nil {
 			if err := h.Envs.ChangeDebugHTTP(c.Name, c.DebugHTTP); err != nil {
 				adminErrorResponse(w, "error changing DebugHTTP", http.StatusInternalServerError, err)
 				h.Inc(metricAdminErr)
 				return
 			}
+			h.recordChange(env, environments.ActivityDebug, ctx[sessions.CtxUser], fmt.Sprintf("debug HTTP set to %t", c.DebugHTTP))
 		}
 		adminOKResponse(w, "debug changed successfully")
+	case "carve_scan":
+		if !carves.ValidScanPolicy(c.CarveScan) {
+			adminErrorResponse(w, "invalid carve scan policy", http.StatusBadRequest, fmt.Errorf("policy %s", c.CarveScan))
+			h.Inc(metricAdminErr)
+			return
+		}
+		if env, err := h.Envs.Get(c.Name); err == nil {
+			if err := h.Envs.UpdateCarveScanPolicy(c.Name, c.CarveScan); err != nil {
+				adminErrorResponse(w, "error changing carve scan policy", http.StatusInternalServerError, err)
+				h.Inc(metricAdminErr)
+				return
+			}
+			h.recordChange(env, environments.ActivityCarves, ctx[sessions.CtxUser], "carve scan policy set to "+c.CarveScan)
+		}
+		adminOKResponse(w, "carve scan policy changed successfully")
+	case "identity":
+		if !nodes.ValidIdentityPolicy(c.Identity) {
+			adminErrorResponse(w, "invalid identity policy", http.StatusBadRequest, fmt.Errorf("policy %s", c.Identity))
+			h.Inc(metricAdminErr)
+			return
+		}
+		if env, err := h.Envs.Get(c.Name); err == nil {
+			if err := h.Envs.UpdateIdentityPolicy(c.Name, c.Identity); err != nil {
+				adminErrorResponse(w, "error changing identity policy", http.StatusInternalServerError, err)
+				h.Inc(metricAdminErr)
+				return
+			}
+			// Nodes are bound again from their enrollment if the environment binds identities later
+			if c.Identity == "" || c.Identity == nodes.IdentityPolicyOff {
+				if err := h.Nodes.UnbindIdentities(env.ID); err != nil {
+					adminErrorResponse(w, "error removing identities", http.StatusInternalServerError, err)
+					h.Inc(metricAdminErr)
+					return
+				}
+			}
+			h.recordChange(env, environments.ActivityIdentity, ctx[sessions.CtxUser], "identity policy set to "+c.Identity)
+		}
+		adminOKResponse(w, "identity policy changed successfully")
+	case "storm":
+		if c.Storm < 0 {
+			adminErrorResponse(w, "invalid storm threshold", http.StatusBadRequest, fmt.Errorf("threshold %d", c.Storm))
+			h.Inc(metricAdminErr)
+			return
+		}
+		if env, err := h.Envs.Get(c.Name); err == nil {
+			if err := h.Envs.UpdateStormThreshold(c.Name, c.Storm); err != nil {
+				adminErrorResponse(w, "error changing storm threshold", http.StatusInternalServerError, err)
+				h.Inc(metricAdminErr)
+				return
+			}
+			h.recordChange(env, environments.ActivityStorm, ctx[sessions.CtxUser], fmt.Sprintf("storm threshold set to %d", c.Storm))
+		}
+		adminOKResponse(w, "storm threshold changed successfully")
+	case "query_expiration":
+		if c.QueryExpiration != 0 {
+			limits := queries.NewExpirationRange(0, h.Settings.QueryExpirationMinHours(), h.Settings.QueryExpirationMaxHours())
+			if _, err := limits.Hours(int64(c.QueryExpiration)); err != nil {
+				adminErrorResponse(w, "invalid query expiration", http.StatusBadRequest, fmt.Errorf("expiration %d - %v", c.QueryExpiration, err))
+				h.Inc(metricAdminErr)
+				return
+			}
+		}
+		if env, err := h.Envs.Get(c.Name); err == nil {
+			if err := h.Envs.UpdateQueryExpiration(c.Name, c.QueryExpiration); err != nil {
+				adminErrorResponse(w, "error changing query expiration", http.StatusInternalServerError, err)
+				h.Inc(metricAdminErr)
+				return
+			}
+			h.recordChange(env, environments.ActivityQueries, ctx[sessions.CtxUser], fmt.Sprintf("default query expiration set to %dh", c.QueryExpiration))
+		}
+		adminOKResponse(w, "query expiration changed successfully")
+	case "category":
+		if !environments.IsCategory(c.Category) {
+			adminErrorResponse(w, "invalid category", http.StatusBadRequest, fmt.Errorf("category %s", c.Category))
+			h.Inc(metricAdminErr)
+			return
+		}
+		if env, err := h.Envs.Get(c.Name); err == nil {
+			if err := h.Envs.UpdateCategory(c.Name, c.Category); err != nil {
+				adminErrorResponse(w, "error changing category", http.StatusInternalServerError, err)
+				h.Inc(metricAdminErr)
+				return
+			}
+			h.recordChange(env, environments.ActivityCategory, ctx[sessions.CtxUser], fmt.Sprintf("category set to %q", c.Category))
+		}
+		adminOKResponse(w, "category changed successfully")
+	case "category_policy":
+		policy := environments.CategoryPolicy{
+			Category:        c.Category,
+			ConfirmTargets:  c.ConfirmTargets,
+			RequireNote:     c.RequireNote,
+			QueryExpiration: c.QueryExpiration,
+		}
+		if err := h.Envs.SetCategoryPolicy(policy, ctx[sessions.CtxUser]); err != nil {
+			adminErrorResponse(w, "error changing category policy", http.StatusBadRequest, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		h.invalidateResponses(cache.ResponseGroupEnvironments)
+		h.recordCategoryPolicy(policy, ctx[sessions.CtxUser])
+		adminOKResponse(w, "category policy changed successfully")
+	case "enroll_loops":
+		if c.EnrollLoopLimit < 0 || c.EnrollLoopQuiet < 0 {
+			adminErrorResponse(w, "invalid enroll loops", http.StatusBadRequest, fmt.Errorf("limit %d quiet %d", c.EnrollLoopLimit, c.EnrollLoopQuiet))
+			h.Inc(metricAdminErr)
+			return
+		}
+		if env, err := h.Envs.Get(c.Name); err == nil {
+			if err := h.Envs.UpdateEnrollLoops(c.Name, c.EnrollLoopLimit, c.EnrollLoopQuiet, c.EnrollBackoff); err != nil {
+				adminErrorResponse(w, "error changing enroll loops", http.StatusInternalServerError, err)
+				h.Inc(metricAdminErr)
+				return
+			}
+			h.recordChange(env, environments.ActivityEnrollLoops, ctx[sessions.CtxUser],
+				fmt.Sprintf("enroll loops set to %d enrolls/hour, %d minutes quiet, backoff %t", c.EnrollLoopLimit, c.EnrollLoopQuiet, c.EnrollBackoff))
+		}
+		adminOKResponse(w, "enroll loops changed successfully")
+	case "naming":
+		if _, err := nodes.ParseNamingDomains(c.NamingDomains); err != nil {
+			adminErrorResponse(w, "invalid naming domains", http.StatusBadRequest, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		env, err := h.Envs.Get(c.Name)
+		if err != nil {
+			adminErrorResponse(w, "error getting environment", http.StatusNotFound, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		if err := h.Envs.UpdateNaming(c.Name, c.NamingLower, c.NamingDomains, c.NamingFQDN); err != nil {
+			adminErrorResponse(w, "error changing naming policy", http.StatusInternalServerError, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		h.recordChange(env, environments.ActivityNaming, ctx[sessions.CtxUser], "naming policy of nodes updated")
+		// Existing nodes keep their names until they are normalized again, show what would change
+		report, err := h.Nodes.Normalize(env.ID, nodes.NewNamingPolicy(c.NamingLower, c.NamingDomains, c.NamingFQDN), true, nodes.DefaultNamingBatch)
+		if err != nil {
+			adminErrorResponse(w, "error checking names of nodes", http.StatusInternalServerError, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, NamingResponse{Message: "naming policy changed successfully", Report: report})
+	case "normalize":
+		env, err := h.Envs.Get(c.Name)
+		if err != nil {
+			adminErrorResponse(w, "error getting environment", http.StatusNotFound, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		policy := nodes.NewNamingPolicy(env.NamingLowercase, env.NamingDomains, env.NamingPreferFQDN)
+		report, err := h.Nodes.Normalize(env.ID, policy, c.DryRun, nodes.DefaultNamingBatch)
+		if err != nil {
+			adminErrorResponse(w, "error normalizing names of nodes", http.StatusInternalServerError, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		msg := fmt.Sprintf("%d of %d names would change, %d collisions", report.Changed, report.Nodes, len(report.Collisions))
+		if !c.DryRun {
+			msg = fmt.Sprintf("%d of %d names normalized, %d collisions", report.Changed, report.Nodes, len(report.Collisions))
+			h.recordActivity(env, environments.ActivityNaming, ctx[sessions.CtxUser], "display names of nodes normalized: "+msg, "", "")
+		}
+		utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, NamingResponse{Message: msg, Report: report})
+	case "pseudonyms":
+		if err := environments.ValidatePseudonymPatterns(c.PseudonymCols + "," + c.PseudonymTables); err != nil {
+			adminErrorResponse(w, "invalid pseudonyms", http.StatusBadRequest, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		if env, err := h.Envs.Get(c.Name); err == nil {
+			if err := h.Envs.UpdatePseudonyms(c.Name, c.PseudonymCols, c.PseudonymTables); err != nil {
+				adminErrorResponse(w, "error changing pseudonyms", http.StatusInternalServerError, err)
+				h.Inc(metricAdminErr)
+				return
+			}
+			h.recordChange(env, environments.ActivityPseudonyms, ctx[sessions.CtxUser], "pseudonymized columns and tables updated")
+		}
+		adminOKResponse(w, "pseudonyms changed successfully")
+	case "locale":
+		if !locales.IsValid(c.Locale) {
+			adminErrorResponse(w, "invalid locale", http.StatusBadRequest, fmt.Errorf("locale %s", c.Locale))
+			h.Inc(metricAdminErr)
+			return
+		}
+		if env, err := h.Envs.Get(c.Name); err == nil {
+			if err := h.Envs.UpdateLocale(c.Name, c.Locale); err != nil {
+				adminErrorResponse(w, "error changing locale", http.StatusInternalServerError, err)
+				h.Inc(metricAdminErr)
+				return
+			}
+			h.recordChange(env, environments.ActivityLocale, ctx[sessions.CtxUser], "locale set to "+locales.Normalize(c.Locale))
+		}
+		adminOKResponse(w, "locale changed successfully")
+	case "platforms":
+		platforms, err := environments.ParsePlatforms(c.Platforms)
+		if err != nil {
+			adminErrorResponse(w, "invalid platforms", http.StatusBadRequest, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		allowed := strings.Join(platforms, ",")
+		flagged := 0
+		if env, err := h.Envs.Get(c.Name); err == nil {
+			if err := h.Envs.UpdateAllowedPlatforms(c.Name, allowed); err != nil {
+				adminErrorResponse(w, "error changing allowed platforms", http.StatusInternalServerError, err)
+				h.Inc(metricAdminErr)
+				return
+			}
+			// Existing nodes out of policy keep working, they are flagged for review
+			flagged, err = h.Nodes.FlagPlatforms(env.ID, allowed, func(platform string) bool {
+				return environments.PlatformAllowed(allowed, platform)
+			})
+			if err != nil {
+				log.Printf("error flagging nodes out of policy %v", err)
+			}
+			h.recordChange(env, environments.ActivityPlatforms, ctx[sessions.CtxUser], fmt.Sprintf("allowed platforms set to %q, %d nodes flagged", allowed, flagged))
+		}
+		adminOKResponse(w, fmt.Sprintf("allowed platforms changed successfully, %d nodes flagged", flagged))
+	case "osquery":
+		pin := strings.TrimSpace(c.OsqueryPin)
+		if pin != "" && !environments.ValidOsqueryVersion(pin) {
+			adminErrorResponse(w, "invalid osquery version", http.StatusBadRequest, fmt.Errorf("version %s", pin))
+			h.Inc(metricAdminErr)
+			return
+		}
+		pins, err := environments.ParseOsqueryPins(c.OsqueryPins)
+		if err != nil {
+			adminErrorResponse(w, "invalid osquery versions by platform", http.StatusBadRequest, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		var warnings []string
+		if env, err := h.Envs.Get(c.Name); err == nil {
+			if err := h.Envs.UpdateOsqueryPin(c.Name, pin, environments.FormatOsqueryPins(pins)); err != nil {
+				adminErrorResponse(w, "error changing pinned osquery version", http.StatusInternalServerError, err)
+				h.Inc(metricAdminErr)
+				return
+			}
+			summary := "osquery version unpinned"
+			if pin != "" || len(pins) > 0 {
+				summary = fmt.Sprintf("osquery version pinned to %q, by platform %q", pin, environments.FormatOsqueryPins(pins))
+			}
+			h.recordChange(env, environments.ActivityOsquery, ctx[sessions.CtxUser], summary)
+			env.OsqueryPin = pin
+			env.OsqueryPins = environments.FormatOsqueryPins(pins)
+			warnings = environments.LintFlagsEnv(env)
+		}
+		if len(warnings) > 0 {
+			adminOKResponse(w, "pinned osquery version changed successfully, flags need a newer version: "+strings.Join(warnings, "; "))
+			break
+		}
+		adminOKResponse(w, "pinned osquery version changed successfully")
 	case "edit":
 		if h.Envs.Exists(c.UUID) {
 			if err := h.Envs.UpdateHostname(c.UUID, c.Hostname); err != nil {
@@ -1192,6 +2023,7 @@ func (h *HandlersAdmin) SettingsPOSTHandler(w http.ResponseWriter, r *http.Reque
 			h.Inc(metricAdminErr)
 			return
 		}
+		h.publishEvent(cache.EventSettings, "", serviceVar)
 		adminOKResponse(w, "setting added successfully")
 	case "change":
 		if !h.Settings.VerifyType(s.Type) {
@@ -1213,6 +2045,7 @@ func (h *HandlersAdmin) SettingsPOSTHandler(w http.ResponseWriter, r *http.Reque
 			h.Inc(metricAdminErr)
 			return
 		}
+		h.publishEvent(cache.EventSettings, "", serviceVar)
 		adminOKResponse(w, "setting changed successfully")
 	case "delete":
 		if err := h.Settings.DeleteValue(serviceVar, s.Name); err != nil {
@@ -1220,7 +2053,54 @@ func (h *HandlersAdmin) SettingsPOSTHandler(w http.ResponseWriter, r *http.Reque
 			h.Inc(metricAdminErr)
 			return
 		}
+		h.publishEvent(cache.EventSettings, "", serviceVar)
 		adminOKResponse(w, "setting deleted successfully")
+	case "always":
+		if serviceVar != settings.ServiceTLS {
+			adminErrorResponse(w, "always logger filters are only for tls", http.StatusBadRequest, nil)
+			h.Inc(metricAdminErr)
+			return
+		}
+		for _, t := range logging.SplitFilter(s.Types) {
+			if t != types.StatusLog && t != types.ResultLog && t != types.QueryLog {
+				adminErrorResponse(w, fmt.Sprintf("invalid log type %s", t), http.StatusBadRequest, nil)
+				h.Inc(metricAdminErr)
+				return
+			}
+		}
+		values := map[string]string{
+			settings.AlwaysLogTypes:    strings.Join(logging.SplitFilter(s.Types), ","),
+			settings.AlwaysLogNames:    strings.Join(logging.SplitFilter(s.Names), ","),
+			settings.AlwaysLogDisabled: strings.Join(logging.SplitFilter(s.DisabledEnvs), ","),
+		}
+		for name, value := range values {
+			var err error
+			if h.Settings.IsValue(serviceVar, name) {
+				err = h.Settings.SetString(value, serviceVar, name, false)
+			} else {
+				err = h.Settings.NewStringValue(serviceVar, name, value)
+			}
+			if err != nil {
+				adminErrorResponse(w, "error changing always logger filters", http.StatusInternalServerError, err)
+				h.Inc(metricAdminErr)
+				return
+			}
+		}
+		h.publishEvent(cache.EventSettings, "", serviceVar)
+		adminOKResponse(w, "always logger filters changed successfully")
+	case "always-estimate":
+		filters := logging.AlwaysFilters{
+			Types:        logging.SplitFilter(s.Types),
+			Names:        logging.SplitFilter(s.Names),
+			DisabledEnvs: logging.SplitFilter(s.DisabledEnvs),
+		}
+		estimate, err := h.alwaysEstimate(filters)
+		if err != nil {
+			adminErrorResponse(w, "error estimating always logger traffic", http.StatusInternalServerError, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		adminOKResponse(w, fmt.Sprintf("%.1f%% of the last hour traffic would match (%d of %d entries)", estimate.Percent, estimate.Matched, estimate.Total))
 	}
 	// Serialize and send response
 	if h.Settings.DebugService(settings.ServiceAdmin) {
@@ -1398,6 +2278,17 @@ func (h *HandlersAdmin) UsersPOSTHandler(w http.ResponseWriter, r *http.Request)
 			}
 			adminOKResponse(w, "admin changed successfully")
 		}
+	case "unlock":
+		kind, subject := users.LoginUser, u.Username
+		if u.IPAddress != "" {
+			kind, subject = users.LoginIP, u.IPAddress
+		}
+		if _, err := h.Users.UnlockLogin(kind, subject, ctx[sessions.CtxUser]); err != nil {
+			adminErrorResponse(w, "error unlocking", http.StatusInternalServerError, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		adminOKResponse(w, "unlocked successfully")
 	}
 	// Serialize and send response
 	if h.Settings.DebugService(settings.ServiceAdmin) {
@@ -1479,7 +2370,7 @@ func (h *HandlersAdmin) TagsPOSTHandler(w http.ResponseWriter, r *http.Request)
 			return
 		}
 		if h.Tags.Exists(t.Name) {
-			if err := h.Tags.Delete(t.Name); err != nil {
+			if err := h.Tags.Delete(t.Name, ctx[sessions.CtxUser]); err != nil {
 				adminErrorResponse(w, "error removing tag", http.StatusInternalServerError, err)
 				h.Inc(metricAdminErr)
 				return
@@ -1522,7 +2413,7 @@ func (h *HandlersAdmin) TagNodesPOSTHandler(w http.ResponseWriter, r *http.Reque
 		h.Inc(metricAdminErr)
 		return
 	}
-	var toBeProcessed []nodes.OsqueryNode
+	nodeIDs := make([]uint, 0, len(t.UUIDs))
 	for _, u := range t.UUIDs {
 		n, err := h.Nodes.GetByUUID(u)
 		if err != nil {
@@ -1530,37 +2421,32 @@ func (h *HandlersAdmin) TagNodesPOSTHandler(w http.ResponseWriter, r *http.Reque
 			h.Inc(metricAdminErr)
 			return
 		}
-		toBeProcessed = append(toBeProcessed, n)
+		nodeIDs = append(nodeIDs, n.ID)
 	}
-	// Processing the list of tags to remove
-	for _, _t := range t.TagsRemove {
-		if !h.Tags.Exists(_t) {
-			adminErrorResponse(w, "error removing tag", http.StatusInternalServerError, fmt.Errorf("tag %s does not exists", _t))
-			h.Inc(metricAdminErr)
-			return
-		}
-		// Untag all nodes
-		for _, n := range toBeProcessed {
-			if err := h.Tags.UntagNode(_t, n); err != nil {
-				adminErrorResponse(w, "error removing tag", http.StatusInternalServerError, err)
-				h.Inc(metricAdminErr)
-				return
-			}
-		}
+	// Tag operations affecting many nodes need to be confirmed
+	job, err := tags.NewTagJob(ctx[sessions.CtxUser], t.TagsAdd, t.TagsRemove, len(nodeIDs), h.Settings.TagJobConfirmThreshold(), t.Confirm)
+	var confirm *tags.ErrTagJobConfirm
+	if errors.As(err, &confirm) {
+		utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, TagNodesResponse{Message: err.Error(), Total: confirm.Total, Confirm: true})
+		h.Inc(metricAdminOK)
+		return
 	}
-	// Processing the list of tags to add and all nodes to tag
-	for _, n := range toBeProcessed {
-		if err := h.Tags.TagNodeMulti(t.TagsAdd, n, ctx[sessions.CtxUser], false); err != nil {
-			adminErrorResponse(w, "error with tag", http.StatusInternalServerError, err)
-			h.Inc(metricAdminErr)
-			return
-		}
+	if err != nil {
+		adminErrorResponse(w, "error with tags", http.StatusBadRequest, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Nodes are tagged in batches in the background
+	if err := h.Tags.CreateJob(&job, nodeIDs); err != nil {
+		adminErrorResponse(w, "error creating tag job", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
 	}
 	// Serialize and send response
 	if h.Settings.DebugService(settings.ServiceAdmin) {
 		log.Println("DebugService: Tags response sent")
 	}
-	adminOKResponse(w, "tags processed successfully")
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, TagNodesResponse{Message: "tags are being processed", JobID: job.ID, Total: job.Total})
 	h.Inc(metricAdminOK)
 }
 
@@ -1627,6 +2513,9 @@ func (h *HandlersAdmin) PermissionsPOSTHandler(w http.ResponseWriter, r *http.Re
 			return
 		}
 	}
+	if err := users.RecordAccessChanges(h.Envs, usernameVar, ctx[sessions.CtxUser], users.UserAccess{env.UUID: existing}, users.UserAccess{env.UUID: perms}); err != nil {
+		log.Printf("error recording permissions activity %v", err)
+	}
 	// Serialize and send response
 	if h.Settings.DebugService(settings.ServiceAdmin) {
 		log.Println("DebugService: Users response sent")
@@ -1635,20 +2524,125 @@ func (h *HandlersAdmin) PermissionsPOSTHandler(w http.ResponseWriter, r *http.Re
 	h.Inc(metricAdminOK)
 }
 
-// EnrollPOSTHandler for POST requests enroll data
-func (h *HandlersAdmin) EnrollPOSTHandler(w http.ResponseWriter, r *http.Request) {
+// PermissionsBulkPOSTHandler for POST requests to set, add or remove user permissions in multiple environments
+func (h *HandlersAdmin) PermissionsBulkPOSTHandler(w http.ResponseWriter, r *http.Request) {
 	h.Inc(metricAdminReq)
 	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), true)
 	vars := mux.Vars(r)
-	// Extract environment
-	envVar, ok := vars["environment"]
-	if !ok {
-		h.Inc(metricAdminErr)
-		log.Println("error getting environment")
+	// Extract username and verify
+	usernameVar, ok := vars["username"]
+	if !ok || !h.Users.Exists(usernameVar) {
+		adminErrorResponse(w, "error getting username", http.StatusInternalServerError, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	var p PermissionsBulkRequest
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Check permissions
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.AdminLevel, users.NoEnvironment) {
+		adminErrorResponse(w, fmt.Sprintf("%s has insuficient permissions", ctx[sessions.CtxUser]), http.StatusForbidden, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Parse request JSON body
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Decoding POST body")
+	}
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		adminErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Check CSRF Token
+	if !sessions.CheckCSRFToken(ctx[sessions.CtxCSRF], p.CSRFToken) {
+		adminErrorResponse(w, "invalid CSRF token", http.StatusInternalServerError, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// All environments must exist before anything is changed
+	envsMap, err := h.Envs.GetMapCtx(r.Context())
+	if err != nil {
+		adminErrorResponse(w, "error getting environments", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	uuids, err := users.ResolveEnvs(envsMap, p.Environments)
+	if err != nil {
+		adminErrorResponse(w, err.Error(), http.StatusBadRequest, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	current, err := h.Users.GetAccess(usernameVar)
+	if err != nil {
+		adminErrorResponse(w, "error getting permissions", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Prepare the complete set of permissions
+	access := make(users.UserAccess)
+	if p.FromUser != "" {
+		if access, err = h.Users.GetAccess(p.FromUser); err != nil {
+			adminErrorResponse(w, "error getting permissions to copy", http.StatusBadRequest, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+	}
+	if len(uuids) > 0 && p.Action != "remove" {
+		levelAccess, err := users.LevelAccess(p.Level)
+		if err != nil {
+			adminErrorResponse(w, "invalid level", http.StatusBadRequest, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		extra := make(users.UserAccess)
+		for _, u := range uuids {
+			extra[u] = levelAccess
+		}
+		access = users.MergeAccess(access, extra)
+	}
+	switch p.Action {
+	case "set":
+	case "add":
+		access = users.MergeAccess(current, access)
+	case "remove":
+		access = users.RemoveAccess(current, uuids)
+	default:
+		adminErrorResponse(w, "invalid action", http.StatusBadRequest, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	before, after, err := h.Users.SetAccess(usernameVar, ctx[sessions.CtxUser], access)
+	if err != nil {
+		adminErrorResponse(w, "error setting permissions", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	if err := users.RecordAccessChanges(h.Envs, usernameVar, ctx[sessions.CtxUser], before, after); err != nil {
+		log.Printf("error recording permissions activity %v", err)
+	}
+	// Serialize and send response
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Bulk permissions response sent")
+	}
+	adminOKResponse(w, "permissions updated successfully")
+	h.Inc(metricAdminOK)
+}
+
+// EnrollPOSTHandler for POST requests enroll data
+func (h *HandlersAdmin) EnrollPOSTHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), true)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["environment"]
+	if !ok {
+		h.Inc(metricAdminErr)
+		log.Println("error getting environment")
 		return
 	}
 	// Get environment
-	env, err := h.Envs.Get(envVar)
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
 	if err != nil {
 		h.Inc(metricAdminErr)
 		log.Printf("error getting environment: %v", err)
@@ -1689,11 +2683,13 @@ func (h *HandlersAdmin) EnrollPOSTHandler(w http.ResponseWriter, r *http.Request
 		h.Inc(metricAdminErr)
 		return
 	}
-	if err := h.Envs.UpdateCertificate(env.UUID, string(certificate)); err != nil {
+	cert, err := h.Envs.StageCertificate(env, string(certificate), e.ActivateAt)
+	if err != nil {
 		adminErrorResponse(w, "error saving certificate", http.StatusInternalServerError, err)
 		h.Inc(metricAdminErr)
 		return
 	}
+	h.recordActivity(env, environments.ActivityCertificate, ctx[sessions.CtxUser], fmt.Sprintf("certificate version %d staged to activate at %s", cert.Version, cert.ActivateAt), env.Certificate, string(certificate))
 	// Serialize and send response
 	if h.Settings.DebugService(settings.ServiceAdmin) {
 		log.Println("DebugService: Configuration response sent")
@@ -1702,6 +2698,437 @@ func (h *HandlersAdmin) EnrollPOSTHandler(w http.ResponseWriter, r *http.Request
 	h.Inc(metricAdminOK)
 }
 
+// EnrollFieldsPOSTHandler for POST requests to change enroll metadata fields
+func (h *HandlersAdmin) EnrollFieldsPOSTHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), true)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["environment"]
+	if !ok {
+		h.Inc(metricAdminErr)
+		log.Println("error getting environment")
+		return
+	}
+	// Get environment
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environment: %v", err)
+		return
+	}
+	var f EnrollFieldsRequest
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Check permissions
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.AdminLevel, env.UUID) {
+		adminErrorResponse(w, fmt.Sprintf("%s has insuficient permissions", ctx[sessions.CtxUser]), http.StatusForbidden, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Parse request JSON body
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Decoding POST body")
+	}
+	if err := json.NewDecoder(r.Body).Decode(&f); err != nil {
+		adminErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Check CSRF Token
+	if !sessions.CheckCSRFToken(ctx[sessions.CtxCSRF], f.CSRFToken) {
+		adminErrorResponse(w, "invalid CSRF token", http.StatusInternalServerError, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	switch f.Action {
+	case "set":
+		field := environments.EnrollField{
+			Name:        f.Name,
+			Description: f.Description,
+			Regex:       f.Regex,
+			Required:    f.Required,
+		}
+		if _, err := h.Envs.SetEnrollField(env.ID, field); err != nil {
+			adminErrorResponse(w, "error saving enroll field", http.StatusBadRequest, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		h.recordActivity(env, environments.ActivityEnrollFields, ctx[sessions.CtxUser], "enroll field "+f.Name+" saved", "", "")
+		adminOKResponse(w, "enroll field saved successfully")
+	case "delete":
+		if err := h.Envs.DeleteEnrollField(env.ID, f.Name); err != nil {
+			adminErrorResponse(w, "error deleting enroll field", http.StatusInternalServerError, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		h.recordActivity(env, environments.ActivityEnrollFields, ctx[sessions.CtxUser], "enroll field "+f.Name+" deleted", "", "")
+		adminOKResponse(w, "enroll field deleted successfully")
+	default:
+		adminErrorResponse(w, "invalid action", http.StatusBadRequest, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Serialize and send response
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Enroll fields response sent")
+	}
+	h.Inc(metricAdminOK)
+}
+
+// ProfilesPOSTHandler for POST requests to change performance profiles
+func (h *HandlersAdmin) ProfilesPOSTHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), true)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["environment"]
+	if !ok {
+		h.Inc(metricAdminErr)
+		log.Println("error getting environment")
+		return
+	}
+	// Get environment
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environment: %v", err)
+		return
+	}
+	var f ProfilesRequest
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Check permissions
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.AdminLevel, env.UUID) {
+		adminErrorResponse(w, fmt.Sprintf("%s has insuficient permissions", ctx[sessions.CtxUser]), http.StatusForbidden, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Parse request JSON body
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Decoding POST body")
+	}
+	if err := json.NewDecoder(r.Body).Decode(&f); err != nil {
+		adminErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Check CSRF Token
+	if !sessions.CheckCSRFToken(ctx[sessions.CtxCSRF], f.CSRFToken) {
+		adminErrorResponse(w, "invalid CSRF token", http.StatusInternalServerError, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	switch f.Action {
+	case "set":
+		profile := environments.PerformanceProfile{
+			Name:           f.Name,
+			Priority:       f.Priority,
+			Tag:            f.Tag,
+			Platform:       f.Platform,
+			HardwareModel:  f.HardwareModel,
+			ConfigInterval: f.ConfigInterval,
+			LogInterval:    f.LogInterval,
+			QueryInterval:  f.QueryInterval,
+		}
+		saved, err := h.Envs.SetProfile(env.ID, profile)
+		if err != nil {
+			adminErrorResponse(w, "error saving profile", http.StatusBadRequest, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		h.recordActivity(env, environments.ActivityProfiles, ctx[sessions.CtxUser], "performance profile "+saved.Name+" saved", "", "")
+		h.publishEvent(cache.EventEnvironment, env.UUID, environments.ActivityProfiles)
+		adminOKResponse(w, "profile saved successfully")
+	case "delete":
+		if err := h.Envs.DeleteProfile(env.ID, f.Name); err != nil {
+			adminErrorResponse(w, "error deleting profile", http.StatusInternalServerError, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		h.recordActivity(env, environments.ActivityProfiles, ctx[sessions.CtxUser], "performance profile "+f.Name+" deleted", "", "")
+		h.publishEvent(cache.EventEnvironment, env.UUID, environments.ActivityProfiles)
+		adminOKResponse(w, "profile deleted successfully")
+	default:
+		adminErrorResponse(w, "invalid action", http.StatusBadRequest, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Serialize and send response
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Profiles response sent")
+	}
+	h.Inc(metricAdminOK)
+}
+
+// StorageQuotaPOSTHandler for POST requests to change the storage quota of an environment
+func (h *HandlersAdmin) StorageQuotaPOSTHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), true)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["environment"]
+	if !ok {
+		h.Inc(metricAdminErr)
+		log.Println("error getting environment")
+		return
+	}
+	// Get environment
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environment: %v", err)
+		return
+	}
+	var q StorageQuotaRequest
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Check permissions
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.AdminLevel, env.UUID) {
+		adminErrorResponse(w, fmt.Sprintf("%s has insuficient permissions", ctx[sessions.CtxUser]), http.StatusForbidden, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Parse request JSON body
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Decoding POST body")
+	}
+	if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+		adminErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Check CSRF Token
+	if !sessions.CheckCSRFToken(ctx[sessions.CtxCSRF], q.CSRFToken) {
+		adminErrorResponse(w, "invalid CSRF token", http.StatusInternalServerError, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	maxBytes, err := environments.ParseStorageSize(q.MaxSize)
+	if err != nil {
+		adminErrorResponse(w, "invalid quota size", http.StatusBadRequest, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	before, err := h.Envs.GetStorageQuota(env.ID)
+	if err != nil {
+		adminErrorResponse(w, "error getting storage quota", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	quota, err := h.Envs.SetStorageQuota(env.ID, maxBytes, strings.Join(q.Actions, ","), ctx[sessions.CtxUser])
+	if err != nil {
+		adminErrorResponse(w, "error setting storage quota", http.StatusBadRequest, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	h.recordActivity(env, environments.ActivityStorage, ctx[sessions.CtxUser], "storage quota updated", quotaSummary(before), quotaSummary(quota))
+	h.publishEvent(cache.EventEnvironment, env.UUID, environments.ActivityStorage)
+	adminOKResponse(w, "storage quota saved successfully")
+	// Serialize and send response
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Storage quota response sent")
+	}
+	h.Inc(metricAdminOK)
+}
+
+// WindowsEventsPOSTHandler for POST requests to change the Windows event channels of an environment
+func (h *HandlersAdmin) WindowsEventsPOSTHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), true)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["environment"]
+	if !ok {
+		h.Inc(metricAdminErr)
+		log.Println("error getting environment")
+		return
+	}
+	// Get environment
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environment: %v", err)
+		return
+	}
+	var c WindowsEventsRequest
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Check permissions
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.AdminLevel, env.UUID) {
+		adminErrorResponse(w, fmt.Sprintf("%s has insuficient permissions", ctx[sessions.CtxUser]), http.StatusForbidden, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Parse request JSON body
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Decoding POST body")
+	}
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		adminErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Check CSRF Token
+	if !sessions.CheckCSRFToken(ctx[sessions.CtxCSRF], c.CSRFToken) {
+		adminErrorResponse(w, "invalid CSRF token", http.StatusInternalServerError, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	before, err := h.Envs.WindowsEventsCtx(r.Context(), env.ID)
+	if err != nil {
+		adminErrorResponse(w, "error getting windows event channels", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	var summary string
+	switch c.Action {
+	case "set":
+		channel := environments.WindowsEventChannel{
+			Channel:  c.Channel,
+			Enabled:  c.Enabled,
+			Interval: c.Interval,
+			MaxRows:  c.MaxRows,
+		}
+		saved, err := h.Envs.SetWindowsEventChannel(env.ID, channel)
+		if err != nil {
+			adminErrorResponse(w, "error saving channel", http.StatusBadRequest, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		summary = "windows event channel " + saved.Channel + " saved"
+	case "delete":
+		if err := h.Envs.DeleteWindowsEventChannel(env.ID, c.Channel); err != nil {
+			adminErrorResponse(w, "error deleting channel", http.StatusInternalServerError, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		summary = "windows event channel " + c.Channel + " deleted"
+	case "import":
+		var imported []environments.WindowsEventSetting
+		if err := json.Unmarshal([]byte(c.Import), &imported); err != nil {
+			adminErrorResponse(w, "error parsing channels", http.StatusBadRequest, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		if _, err := h.Envs.ImportWindowsEvents(env.ID, imported); err != nil {
+			adminErrorResponse(w, "error importing channels", http.StatusBadRequest, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		summary = fmt.Sprintf("%d windows event channels imported", len(imported))
+	default:
+		adminErrorResponse(w, "invalid action", http.StatusBadRequest, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	after, err := h.Envs.WindowsEventsCtx(r.Context(), env.ID)
+	if err != nil {
+		log.Printf("error getting windows event channels: %v", err)
+	}
+	h.recordActivity(env, environments.ActivityWindowsEvents, ctx[sessions.CtxUser], summary, environments.SerializeWindowsEvents(before), environments.SerializeWindowsEvents(after))
+	h.publishEvent(cache.EventEnvironment, env.UUID, environments.ActivityWindowsEvents)
+	adminOKResponse(w, summary)
+	// Serialize and send response
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Windows events response sent")
+	}
+	h.Inc(metricAdminOK)
+}
+
+// APIKeysPOSTHandler for POST requests to create, rotate and revoke service keys of an environment
+func (h *HandlersAdmin) APIKeysPOSTHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), true)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["environment"]
+	if !ok {
+		h.Inc(metricAdminErr)
+		log.Println("error getting environment")
+		return
+	}
+	// Get environment
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environment: %v", err)
+		return
+	}
+	var k APIKeysRequest
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Check permissions
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.AdminLevel, env.UUID) {
+		adminErrorResponse(w, fmt.Sprintf("%s has insuficient permissions", ctx[sessions.CtxUser]), http.StatusForbidden, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Parse request JSON body
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Decoding POST body")
+	}
+	if err := json.NewDecoder(r.Body).Decode(&k); err != nil {
+		adminErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Check CSRF Token
+	if !sessions.CheckCSRFToken(ctx[sessions.CtxCSRF], k.CSRFToken) {
+		adminErrorResponse(w, "invalid CSRF token", http.StatusInternalServerError, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	expires := users.APIKeyExpiration(k.Days, time.Now())
+	switch k.Action {
+	case "add":
+		scopes, err := users.ParseScopes(strings.Join(k.Scopes, ","))
+		if err != nil {
+			adminErrorResponse(w, "invalid scopes", http.StatusBadRequest, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		key, token, err := h.Users.NewAPIKey(k.Name, env.UUID, scopes, expires, ctx[sessions.CtxUser])
+		if err != nil {
+			adminErrorResponse(w, "error creating key", http.StatusBadRequest, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		h.recordActivity(env, environments.ActivityAPIKeys, ctx[sessions.CtxUser], "service key "+key.Name+" created with scopes "+key.Scopes, "", "")
+		adminOKResponse(w, "store the key now, it will not be shown again: "+token)
+	case "rotate":
+		overlap := users.DefaultAPIKeyOverlap
+		if k.Overlap > 0 {
+			overlap = time.Duration(k.Overlap) * time.Hour
+		}
+		key, token, err := h.Users.RotateAPIKey(k.Name, env.UUID, expires, overlap, ctx[sessions.CtxUser])
+		if err != nil {
+			adminErrorResponse(w, "error rotating key", http.StatusBadRequest, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		h.recordActivity(env, environments.ActivityAPIKeys, ctx[sessions.CtxUser], fmt.Sprintf("service key %s rotated, previous keys expire in %s", key.Name, overlap), "", "")
+		adminOKResponse(w, "store the key now, it will not be shown again: "+token)
+	case "revoke":
+		if err := h.Users.RevokeAPIKey(k.KeyID, env.UUID); err != nil {
+			adminErrorResponse(w, "error revoking key", http.StatusInternalServerError, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		h.recordActivity(env, environments.ActivityAPIKeys, ctx[sessions.CtxUser], "service key "+k.KeyID+" revoked", "", "")
+		adminOKResponse(w, "key revoked successfully")
+	default:
+		adminErrorResponse(w, "invalid action", http.StatusBadRequest, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Serialize and send response
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Service keys response sent")
+	}
+	h.Inc(metricAdminOK)
+}
+
 // EditProfilePOSTHandler for POST requests to edit profile
 func (h *HandlersAdmin) EditProfilePOSTHandler(w http.ResponseWriter, r *http.Request) {
 	h.Inc(metricAdminReq)
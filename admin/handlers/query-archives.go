@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/admin/sessions"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/queries"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+// QueryRehydratePOSTHandler for POST requests to rehydrate the results of archived queries, they are restored in
+// the background by osctrl-api
+func (h *HandlersAdmin) QueryRehydratePOSTHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), true)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		log.Println("environment is missing")
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Get environment
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		log.Printf("error getting environment %s - %v", envVar, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Check permissions for query
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.QueryLevel, env.UUID) {
+		adminErrorResponse(w, fmt.Sprintf("%s has insuficient permissions", ctx[sessions.CtxUser]), http.StatusForbidden, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Parse request JSON body
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Decoding POST body")
+	}
+	var q QueryRehydrateRequest
+	if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+		adminErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Check CSRF Token
+	if !sessions.CheckCSRFToken(ctx[sessions.CtxCSRF], q.CSRFToken) {
+		adminErrorResponse(w, "invalid CSRF token", http.StatusInternalServerError, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Only who can see the results can rehydrate them
+	query, err := h.Queries.Get(q.Name, env.ID)
+	if err != nil || !h.canViewQuery(r.Context(), query, ctx[sessions.CtxUser]) {
+		adminErrorResponse(w, "query not found", http.StatusNotFound, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	archive, err := h.Queries.RequestRehydrate(query.Name, env.ID, ctx[sessions.CtxUser])
+	if err != nil {
+		adminErrorResponse(w, "error rehydrating query", http.StatusConflict, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	h.recordActivity(env, environments.ActivityArchives, ctx[sessions.CtxUser], queries.ArchiveSummary(archive), "", "")
+	// Serialize and send response
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Printf("DebugService: Rehydration of %s requested", archive.Name)
+	}
+	adminOKResponse(w, "rehydration of "+archive.Name+" requested")
+	h.Inc(metricAdminOK)
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/admin/sessions"
+	"github.com/jmpsec/osctrl/cache"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/queries"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+// Helper to iterate the rows of cached query logs, with the node and the time they were collected
+func queryLogRows(logs []cache.CachedQueryWriteData, fn func(node string, collected time.Time, row map[string]string) error) error {
+	for _, l := range logs {
+		rows, err := queries.ParseResultRows(l.QueryData.Result)
+		if err != nil {
+			log.Printf("error with query log from %s - %v", l.HostIdentifier, err)
+			continue
+		}
+		for _, row := range rows {
+			if err := fn(l.HostIdentifier, time.Unix(int64(l.UnixTime), 0), row); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// QueryExportHandler GET requests to export the results of a query with typed columns as CSV, JSON or Parquet
+func (h *HandlersAdmin) QueryExportHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), false)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		h.Inc(metricAdminErr)
+		log.Println("error getting environment")
+		return
+	}
+	// Get environment
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environment: %v", err)
+		return
+	}
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Check permissions
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.QueryLevel, env.UUID) {
+		log.Printf("%s has insuficient permissions", ctx[sessions.CtxUser])
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Extract name
+	name, ok := vars["name"]
+	if !ok {
+		h.Inc(metricAdminErr)
+		log.Println("error getting name")
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if !queries.ValidExportFormat(format) {
+		h.Inc(metricAdminErr)
+		log.Printf("invalid export format %s", format)
+		utils.HTTPResponse(w, "", http.StatusBadRequest, []byte("invalid export format"))
+		return
+	}
+	// Get query by name
+	query, err := h.Queries.Get(name, env.ID)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting query %v", err)
+		return
+	}
+	// Results of restricted queries are only exported by who can see them
+	if !h.canViewQuery(r.Context(), query, ctx[sessions.CtxUser]) {
+		h.Inc(metricAdminErr)
+		utils.HTTPResponse(w, "", http.StatusForbidden, []byte("query results are restricted"))
+		return
+	}
+	if h.RedisCache == nil {
+		h.Inc(metricAdminErr)
+		log.Println("error exporting results without cache")
+		return
+	}
+	queryLogs, err := h.RedisCache.QueryLogs(name)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting logs %v", err)
+		return
+	}
+	// Values of pseudonymized columns are exported as they are sent to the logger of the environment
+	rows := func(fn func(node string, collected time.Time, row map[string]string) error) error {
+		return queryLogRows(queryLogs, fn)
+	}
+	if p := environments.NewPseudonymizer(env); p != nil {
+		rows = func(fn func(node string, collected time.Time, row map[string]string) error) error {
+			return queryLogRows(queryLogs, func(node string, collected time.Time, row map[string]string) error {
+				return fn(node, collected, p.Row(name, row))
+			})
+		}
+	}
+	// Rows are read twice, first to infer the schema and then to export the typed values
+	inference := queries.NewSchemaInference()
+	_ = rows(func(node string, collected time.Time, row map[string]string) error {
+		inference.Add(row)
+		return nil
+	})
+	filename := fmt.Sprintf("%s-%s.%s", name, env.Name, format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Header().Set(utils.ContentType, queries.ExportContentType(format))
+	w.WriteHeader(http.StatusOK)
+	counter := &environments.AccessCounter{ResponseWriter: w}
+	exporter, err := queries.NewResultExporter(counter, format, inference.Schema())
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error exporting results of %s %v", name, err)
+		return
+	}
+	if err := rows(exporter.Write); err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error exporting results of %s %v", name, err)
+		return
+	}
+	if err := exporter.Close(); err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error exporting results of %s %v", name, err)
+		return
+	}
+	h.recordAccess(r, env.ID, ctx[sessions.CtxUser], environments.AccessQueryResult, name, "export as "+format, counter.Bytes)
+	h.Inc(metricAdminOK)
+}
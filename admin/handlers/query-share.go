@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/admin/sessions"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/jmpsec/osctrl/queries"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+// Helper to generate a sharing export from the cached results, the outcome is recorded in the activity of the environment
+func (h *HandlersAdmin) generateShare(env environments.TLSEnvironment, share queries.ShareExport) {
+	src := queries.ShareSource{
+		Query: func(name string) (queries.DistributedQuery, error) {
+			query, err := h.Queries.Get(name, env.ID)
+			if err == nil && query.ID == 0 {
+				err = fmt.Errorf("record not found")
+			}
+			return query, err
+		},
+		Rows: func(name string, fn func(node string, collected time.Time, row map[string]string) error) error {
+			queryLogs, err := h.RedisCache.QueryLogs(name)
+			if err != nil {
+				return err
+			}
+			return queryLogRows(queryLogs, fn)
+		},
+		Node: func(uuid string) (nodes.OsqueryNode, error) {
+			return h.Nodes.GetByUUIDEnv(uuid, env.ID)
+		},
+	}
+	manifest, err := h.Queries.GenerateShare(context.Background(), share, src)
+	if err != nil {
+		log.Printf("error generating sharing export %s %v", share.Name, err)
+		h.recordActivity(env, environments.ActivityShares, share.Creator, share.Summary()+" failed", "", err.Error())
+		return
+	}
+	scope, err := json.Marshal(manifest)
+	if err != nil {
+		log.Printf("error serializing manifest of %s %v", share.Name, err)
+	}
+	h.recordActivity(env, environments.ActivityShares, share.Creator, share.Summary()+" generated", "", string(scope))
+}
+
+// QuerySharePOSTHandler for POST requests of sharing exports of queries, generated asynchronously
+func (h *HandlersAdmin) QuerySharePOSTHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), true)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		log.Println("environment is missing")
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Get environment
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		log.Printf("error getting environment %s - %v", envVar, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Check permissions for query
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.QueryLevel, env.UUID) {
+		adminErrorResponse(w, fmt.Sprintf("%s has insuficient permissions", ctx[sessions.CtxUser]), http.StatusForbidden, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Parse request JSON body
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Decoding POST body")
+	}
+	var s QueryShareRequest
+	if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+		adminErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Check CSRF Token
+	if !sessions.CheckCSRFToken(ctx[sessions.CtxCSRF], s.CSRFToken) {
+		adminErrorResponse(w, "invalid CSRF token", http.StatusInternalServerError, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	if h.RedisCache == nil {
+		adminErrorResponse(w, "sharing exports need the cache of results", http.StatusInternalServerError, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	var names []string
+	for _, n := range s.Queries {
+		if n = strings.TrimSpace(n); n == "" {
+			continue
+		}
+		// Only queries of the environment can be shared
+		query, err := h.Queries.GetCtx(r.Context(), n, env.ID)
+		if err != nil || query.ID == 0 {
+			adminErrorResponse(w, "query "+n+" not found", http.StatusNotFound, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		// Results of restricted queries are only shared by who can see them
+		if !h.canViewQuery(r.Context(), query, ctx[sessions.CtxUser]) {
+			adminErrorResponse(w, "results of query "+n+" are restricted", http.StatusForbidden, nil)
+			h.Inc(metricAdminErr)
+			return
+		}
+		names = append(names, n)
+	}
+	denyColumns := []string{}
+	for _, c := range strings.Split(s.DenyColumns, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			denyColumns = append(denyColumns, c)
+		}
+	}
+	req := queries.ShareRequest{
+		EnvironmentID: env.ID,
+		Environment:   env.Name,
+		Queries:       removeStringDuplicates(names),
+		Format:        s.Format,
+		Redaction:     queries.ShareRedaction{DropIPs: s.DropIPs, DenyColumns: denyColumns},
+		Creator:       ctx[sessions.CtxUser],
+	}
+	share, err := h.Queries.NewShare(&req)
+	if err != nil {
+		adminErrorResponse(w, "error creating sharing export", http.StatusBadRequest, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	go h.generateShare(env, share)
+	// Serialize and send response
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Sharing export response sent")
+	}
+	adminOKResponse(w, "sharing export "+share.Name+" requested, it will be ready to download shortly")
+	h.Inc(metricAdminOK)
+}
+
+// QueryShareDownloadHandler for GET requests to download a sharing export once ready
+func (h *HandlersAdmin) QueryShareDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), false)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		h.Inc(metricAdminErr)
+		log.Println("error getting environment")
+		return
+	}
+	// Get environment
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environment: %v", err)
+		return
+	}
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Check permissions
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.QueryLevel, env.UUID) {
+		log.Printf("%s has insuficient permissions", ctx[sessions.CtxUser])
+		h.Inc(metricAdminErr)
+		return
+	}
+	share, err := h.Queries.GetShare(vars["name"], env.ID)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting sharing export %v", err)
+		utils.HTTPResponse(w, "", http.StatusNotFound, []byte("sharing export not found"))
+		return
+	}
+	for _, n := range share.QueryNames() {
+		if !h.canViewName(r.Context(), n, env.ID, ctx[sessions.CtxUser]) {
+			h.Inc(metricAdminErr)
+			utils.HTTPResponse(w, "", http.StatusForbidden, []byte("results of query "+n+" are restricted"))
+			return
+		}
+	}
+	if share.Status != queries.ShareReady {
+		h.Inc(metricAdminErr)
+		utils.HTTPResponse(w, "", http.StatusConflict, []byte("sharing export is "+share.Status))
+		return
+	}
+	h.recordActivity(env, environments.ActivityShares, ctx[sessions.CtxUser], "sharing export "+share.Name+" downloaded", "", share.SHA256)
+	w.Header().Set(utils.ContentType, queries.ShareContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", share.Name+".zip"))
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(share.Content); err != nil {
+		log.Printf("error sending sharing export %v", err)
+	}
+	// Each query of the export is recorded, so the results of all of them show who downloaded them
+	for _, n := range share.QueryNames() {
+		h.recordAccess(r, env.ID, ctx[sessions.CtxUser], environments.AccessQueryResult, n, "sharing export "+share.Name, int64(len(share.Content)))
+	}
+	h.Inc(metricAdminOK)
+}
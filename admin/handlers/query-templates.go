@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/jmpsec/osctrl/admin/sessions"
+	"github.com/jmpsec/osctrl/queries"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+// Helper to prepare the query templates for templates, with their versions if requested
+func (h *HandlersAdmin) queryTemplateViews(versions bool) ([]QueryTemplateView, error) {
+	all, err := h.Queries.GetTemplates()
+	if err != nil {
+		return nil, err
+	}
+	views := make([]QueryTemplateView, 0, len(all))
+	for _, t := range all {
+		vars, err := t.Vars()
+		if err != nil {
+			log.Printf("error getting variables of template %s: %v", t.Name, err)
+			continue
+		}
+		v := QueryTemplateView{
+			Name:        t.Name,
+			Description: t.Description,
+			Query:       t.Query,
+			Variables:   vars,
+			Platforms:   t.PlatformList(),
+			Risk:        t.Risk,
+			Team:        t.Team,
+			Approved:    t.Approved,
+			Version:     t.Version,
+		}
+		if versions {
+			if v.Versions, err = h.Queries.GetTemplateVersions(t.Name); err != nil {
+				return nil, err
+			}
+		}
+		views = append(views, v)
+	}
+	return views, nil
+}
+
+// Helper to parse the variables of a template request, one per line
+func parseTemplateVariables(raw string) ([]queries.TemplateVariable, error) {
+	var vars []queries.TemplateVariable
+	for _, line := range strings.Split(raw, "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		v, err := queries.ParseTemplateVariable(line)
+		if err != nil {
+			return vars, err
+		}
+		vars = append(vars, v)
+	}
+	return vars, nil
+}
+
+// QueryTemplatesGETHandler for GET requests for /templates
+func (h *HandlersAdmin) QueryTemplatesGETHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), false)
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Custom functions to handle formatting
+	funcMap := template.FuncMap{
+		"pastFutureTimes": utils.PastFutureTimes,
+	}
+	// Prepare template
+	tempateFiles := h.NewTemplateFiles(h.TemplatesFolder, "query-templates.html").filepaths
+	t, err := template.New("query-templates.html").Funcs(funcMap).ParseFiles(tempateFiles...)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting query templates template: %v", err)
+		return
+	}
+	// Get stats for all environments
+	envAll, err := h.Envs.AllCtx(r.Context())
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environments %v", err)
+		return
+	}
+	// Get stats for all platforms
+	platforms, err := h.Nodes.GetAllPlatforms()
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting platforms: %v", err)
+		return
+	}
+	// Get query templates with their versions
+	views, err := h.queryTemplateViews(true)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting query templates: %v", err)
+		return
+	}
+	// Prepare template data
+	templateData := QueryTemplatesTemplateData{
+		Title:        "Query templates",
+		Metadata:     h.TemplateMetadata(ctx, h.ServiceVersion),
+		Environments: h.allowedEnvironments(ctx[sessions.CtxUser], envAll),
+		Platforms:    platforms,
+		Templates:    views,
+		Risks:        queries.TemplateRisks,
+		CanModify:    h.Users.CheckPermissions(ctx[sessions.CtxUser], users.AdminLevel, users.NoEnvironment),
+	}
+	if err := t.Execute(w, templateData); err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("template error %v", err)
+		return
+	}
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Query templates template served")
+	}
+	h.Inc(metricAdminOK)
+}
+
+// QueryTemplatesPOSTHandler for POST requests for /templates, only admins modify templates
+func (h *HandlersAdmin) QueryTemplatesPOSTHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), true)
+	var tr QueryTemplatesRequest
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Check permissions
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.AdminLevel, users.NoEnvironment) {
+		adminErrorResponse(w, fmt.Sprintf("%s has insuficient permissions", ctx[sessions.CtxUser]), http.StatusForbidden, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Parse request JSON body
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Decoding POST body")
+	}
+	if err := json.NewDecoder(r.Body).Decode(&tr); err != nil {
+		adminErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Check CSRF Token
+	if !sessions.CheckCSRFToken(ctx[sessions.CtxCSRF], tr.CSRFToken) {
+		adminErrorResponse(w, "invalid CSRF token", http.StatusInternalServerError, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	switch tr.Action {
+	case "create", "update":
+		vars, err := parseTemplateVariables(tr.Variables)
+		if err != nil {
+			adminErrorResponse(w, "invalid template variables", http.StatusBadRequest, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		t := queries.QueryTemplate{
+			Name:        strings.TrimSpace(tr.Name),
+			Description: tr.Description,
+			Query:       tr.Query,
+			Variables:   queries.EncodeTemplateVariables(vars),
+			Platforms:   tr.Platforms,
+			Risk:        tr.Risk,
+			Team:        tr.Team,
+			Approved:    tr.Approved,
+			Creator:     ctx[sessions.CtxUser],
+		}
+		if tr.Action == "create" {
+			if _, err := h.Queries.GetTemplate(t.Name); err == nil {
+				adminErrorResponse(w, "template already exists", http.StatusConflict, nil)
+				h.Inc(metricAdminErr)
+				return
+			}
+			t, err = h.Queries.CreateTemplate(t, tr.Changelog)
+		} else {
+			t, err = h.Queries.UpdateTemplate(t.Name, t, ctx[sessions.CtxUser], tr.Changelog)
+		}
+		if err != nil {
+			adminErrorResponse(w, "error saving template", http.StatusBadRequest, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		adminOKResponse(w, fmt.Sprintf("template %s version %d saved successfully", t.Name, t.Version))
+	case "delete":
+		if err := h.Queries.DeleteTemplate(tr.Name, ctx[sessions.CtxUser]); err != nil {
+			adminErrorResponse(w, "error deleting template", http.StatusInternalServerError, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		adminOKResponse(w, "template deleted successfully")
+	default:
+		adminErrorResponse(w, "invalid action", http.StatusBadRequest, fmt.Errorf("invalid action %s", tr.Action))
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Serialize and send response
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Query templates response sent")
+	}
+	h.Inc(metricAdminOK)
+}
@@ -0,0 +1,317 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/admin/sessions"
+	"github.com/jmpsec/osctrl/cache"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/jmpsec/osctrl/queries"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+// Helper to get the permission needed to run each type of quick action
+func quickActionLevel(kind string) users.AccessLevel {
+	switch kind {
+	case queries.QuickActionQuery, queries.QuickActionAccelerate:
+		return users.QueryLevel
+	case queries.QuickActionCarve:
+		return users.CarveLevel
+	}
+	return users.AdminLevel
+}
+
+// Helper to describe a quick action for the activity of the environment
+func quickActionSummary(a queries.QuickAction) string {
+	if a.Name == "" {
+		return ""
+	}
+	return fmt.Sprintf("version: %d\nlabel: %s\ntype: %s\n%s", a.Version, a.Label, a.Type, a.Definition())
+}
+
+// Helper to get the quick actions of an environment that a user can run, resolved for rendering
+func (h *HandlersAdmin) quickActionsFor(username string, env environments.TLSEnvironment) []queries.QuickAction {
+	actions, err := h.Queries.GetQuickActions(env.ID)
+	if err != nil {
+		log.Printf("error getting quick actions %v", err)
+		return nil
+	}
+	var allowed []queries.QuickAction
+	for _, a := range actions {
+		if h.Users.CheckPermissions(username, quickActionLevel(a.Type), env.UUID) {
+			allowed = append(allowed, a)
+		}
+	}
+	return allowed
+}
+
+// Helper to get the templates that quick actions can reference, only approved ones
+func (h *HandlersAdmin) approvedTemplates() []queries.QueryTemplate {
+	all, err := h.Queries.GetTemplates()
+	if err != nil {
+		log.Printf("error getting query templates %v", err)
+		return nil
+	}
+	var approved []queries.QueryTemplate
+	for _, t := range all {
+		if t.Approved {
+			approved = append(approved, t)
+		}
+	}
+	return approved
+}
+
+// QuickActionsPOSTHandler for POST requests to create, update or delete the quick actions of an environment
+func (h *HandlersAdmin) QuickActionsPOSTHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), true)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["environment"]
+	if !ok {
+		h.Inc(metricAdminErr)
+		log.Println("error getting environment")
+		return
+	}
+	// Get environment
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environment: %v", err)
+		return
+	}
+	var q QuickActionRequest
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	user := ctx[sessions.CtxUser]
+	// Check permissions
+	if !h.Users.CheckPermissions(user, users.AdminLevel, env.UUID) {
+		adminErrorResponse(w, fmt.Sprintf("%s has insuficient permissions", user), http.StatusForbidden, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Parse request JSON body
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Decoding POST body")
+	}
+	if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+		adminErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Check CSRF Token
+	if !sessions.CheckCSRFToken(ctx[sessions.CtxCSRF], q.CSRFToken) {
+		adminErrorResponse(w, "invalid CSRF token", http.StatusInternalServerError, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	before, _ := h.Queries.GetQuickAction(q.Name, env.ID)
+	switch q.Action {
+	case "set":
+		values, err := queries.ParseQuickActionVariables(q.Variables)
+		if err != nil {
+			adminErrorResponse(w, err.Error(), http.StatusBadRequest, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		a := queries.QuickAction{
+			Name:     q.Name,
+			Label:    q.Label,
+			Icon:     q.Icon,
+			Type:     q.Type,
+			Template: q.Template,
+			Path:     q.Path,
+			Tag:      q.Tag,
+		}
+		if len(values) > 0 {
+			raw, _ := json.Marshal(values)
+			a.Variables = string(raw)
+		}
+		saved, err := h.Queries.SetQuickAction(env.ID, a, user)
+		if err != nil {
+			adminErrorResponse(w, "error saving quick action: "+err.Error(), http.StatusBadRequest, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		h.recordActivity(env, environments.ActivityQuickActions, user, fmt.Sprintf("quick action %s saved as version %d", saved.Name, saved.Version),
+			quickActionSummary(before), quickActionSummary(saved))
+		adminOKResponse(w, "quick action saved successfully")
+	case "delete":
+		if err := h.Queries.DeleteQuickAction(q.Name, env.ID, user); err != nil {
+			adminErrorResponse(w, "error deleting quick action", http.StatusNotFound, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		h.recordActivity(env, environments.ActivityQuickActions, user, "quick action "+q.Name+" deleted", quickActionSummary(before), "")
+		adminOKResponse(w, "quick action deleted successfully")
+	default:
+		adminErrorResponse(w, "invalid action", http.StatusBadRequest, fmt.Errorf("invalid action %s", q.Action))
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Serialize and send response
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Quick actions response sent")
+	}
+	h.Inc(metricAdminOK)
+}
+
+// QuickActionRunPOSTHandler for POST requests to preview or run a quick action against nodes of an environment
+func (h *HandlersAdmin) QuickActionRunPOSTHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), true)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["environment"]
+	if !ok {
+		h.Inc(metricAdminErr)
+		log.Println("error getting environment")
+		return
+	}
+	// Get environment
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environment: %v", err)
+		return
+	}
+	var q QuickActionRunRequest
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	user := ctx[sessions.CtxUser]
+	// Parse request JSON body
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Decoding POST body")
+	}
+	if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+		adminErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Check CSRF Token
+	if !sessions.CheckCSRFToken(ctx[sessions.CtxCSRF], q.CSRFToken) {
+		adminErrorResponse(w, "invalid CSRF token", http.StatusInternalServerError, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	a, err := h.Queries.GetQuickAction(q.Name, env.ID)
+	if err != nil {
+		adminErrorResponse(w, "quick action not found", http.StatusNotFound, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Check permissions, each type of quick action needs its own
+	if !h.Users.CheckPermissions(user, quickActionLevel(a.Type), env.UUID) {
+		adminErrorResponse(w, fmt.Sprintf("%s has insuficient permissions", user), http.StatusForbidden, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	var tpl *queries.QueryTemplate
+	if a.Type == queries.QuickActionQuery {
+		t, err := h.Queries.GetTemplate(a.Template)
+		if err != nil {
+			adminErrorResponse(w, "template of quick action not found", http.StatusConflict, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		tpl = &t
+	}
+	plan, err := queries.PlanQuickAction(a, tpl, q.UUIDs)
+	if err != nil {
+		adminErrorResponse(w, err.Error(), http.StatusBadRequest, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Nodes must belong to the environment
+	targets := make([]nodes.OsqueryNode, 0, len(plan.Targets))
+	for _, u := range plan.Targets {
+		node, err := h.Nodes.GetByUUIDEnv(u, env.ID)
+		if err != nil {
+			adminErrorResponse(w, "node "+u+" not found in "+env.Name, http.StatusBadRequest, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		targets = append(targets, node)
+	}
+	if q.Action == "preview" {
+		utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, plan)
+		h.Inc(metricAdminOK)
+		return
+	}
+	if q.Action != "run" {
+		adminErrorResponse(w, "invalid action", http.StatusBadRequest, fmt.Errorf("invalid action %s", q.Action))
+		h.Inc(metricAdminErr)
+		return
+	}
+	// What runs must be what was confirmed
+	if q.Version != a.Version {
+		adminErrorResponse(w, "quick action changed since it was confirmed, try again", http.StatusConflict, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	if a.Type != queries.QuickActionTag {
+		// Critical notices of the environment must be confirmed
+		if err := h.Users.CheckConfirmation(env.UUID, q.ConfirmNotices); err != nil {
+			adminErrorResponse(w, err.Error(), http.StatusConflict, nil)
+			h.Inc(metricAdminErr)
+			return
+		}
+	}
+	var launched []string
+	switch a.Type {
+	case queries.QuickActionTag:
+		for _, n := range targets {
+			if err := h.Tags.TagNode(plan.Tag, n, user, false); err != nil && err.Error() != "node already tagged" {
+				adminErrorResponse(w, "error tagging node", http.StatusInternalServerError, err)
+				h.Inc(metricAdminErr)
+				return
+			}
+		}
+	case queries.QuickActionCarve:
+		// New carves are blocked while the storage quota of the environment is exceeded
+		quota, err := h.Envs.GetStorageQuota(env.ID)
+		if err != nil {
+			adminErrorResponse(w, "error getting storage quota", http.StatusInternalServerError, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		if quota.Active(environments.QuotaBlockCarves) {
+			adminErrorResponse(w, "storage quota exceeded, new carves are blocked", http.StatusInsufficientStorage, nil)
+			h.Inc(metricAdminErr)
+			return
+		}
+		fallthrough
+	default:
+		expiresAt, err := h.queryExpiration(env).ExpiresAt("", time.Now())
+		if err != nil {
+			adminErrorResponse(w, "invalid expiration", http.StatusBadRequest, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		launched, err = h.Queries.LaunchQuickAction(plan, env.ID, user, expiresAt)
+		if err != nil {
+			adminErrorResponse(w, "error running quick action", http.StatusInternalServerError, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		for _, name := range launched {
+			h.publishEvent(cache.EventQuery, env.UUID, name)
+		}
+	}
+	h.recordActivity(env, environments.ActivityQuickActions, user, queries.QuickActionRunSummary(plan, launched), "", plan.Summary)
+	adminOKResponse(w, fmt.Sprintf("quick action %s run on %d node(s)", a.Label, len(plan.Targets)))
+	// Serialize and send response
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Printf("DebugService: Quick action %s run by %s on %s", a.Name, user, strings.Join(plan.Targets, ","))
+	}
+	h.Inc(metricAdminOK)
+}
@@ -0,0 +1,273 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/admin/sessions"
+	"github.com/jmpsec/osctrl/queries"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+// Helper to get the storage used by the carves of a case
+func (h *HandlersAdmin) caseFootprint(caseID string) (queries.CaseFootprint, error) {
+	footprint := queries.CaseFootprint{CaseID: caseID}
+	names, err := h.Queries.CaseQueryNames(caseID)
+	if err != nil {
+		return footprint, err
+	}
+	footprint.Bytes, footprint.Carves, err = h.Carves.Footprint(names)
+	return footprint, err
+}
+
+// Helper to arrange the occurrences of a recurring carve by time and node, with the status of the carve in each node
+func (h *HandlersAdmin) recurringGrid(rc queries.RecurringCarve, runs []queries.RecurringCarveRun) (RecurringGrid, error) {
+	grid := RecurringGrid{Nodes: rc.UUIDList()}
+	var names []string
+	for _, r := range runs {
+		if r.QueryName != "" {
+			names = append(names, r.QueryName)
+		}
+	}
+	carved, err := h.Carves.GetByQueries(names)
+	if err != nil {
+		return grid, err
+	}
+	status := make(map[string]string, len(carved))
+	for _, c := range carved {
+		status[c.QueryName+"/"+c.UUID] = c.Status
+	}
+	// Runs come newest first, one per node in each occurrence
+	cells := make(map[time.Time]map[string]RecurringCell)
+	for _, r := range runs {
+		if _, ok := cells[r.Occurrence]; !ok {
+			cells[r.Occurrence] = make(map[string]RecurringCell)
+			grid.Rows = append(grid.Rows, RecurringGridRow{Occurrence: r.Occurrence})
+		}
+		cell := RecurringCell{Status: r.Status, Detail: r.Reason, QueryName: r.QueryName}
+		if r.Status == queries.RunLaunched {
+			cell.Detail = "pending"
+			if s, ok := status[r.QueryName+"/"+r.UUID]; ok {
+				cell.Detail = s
+			}
+		}
+		cells[r.Occurrence][r.UUID] = cell
+	}
+	for i, row := range grid.Rows {
+		for _, u := range grid.Nodes {
+			grid.Rows[i].Cells = append(grid.Rows[i].Cells, cells[row.Occurrence][u])
+		}
+	}
+	return grid, nil
+}
+
+// RecurringCarvesGETHandler for GET requests for /carves/{env}/recurring to show recurring carves and their occurrences
+func (h *HandlersAdmin) RecurringCarvesGETHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), false)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		h.Inc(metricAdminErr)
+		log.Println("error getting environment")
+		return
+	}
+	// Get environment
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environment: %v", err)
+		return
+	}
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Check permissions
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.CarveLevel, env.UUID) {
+		log.Printf("%s has insuficient permissions", ctx[sessions.CtxUser])
+		h.Inc(metricAdminErr)
+		return
+	}
+	recurring, err := h.Queries.GetRecurringCarves(env.ID)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting recurring carves: %v", err)
+		return
+	}
+	var details []queries.RecurringCarveDetail
+	for _, rc := range recurring {
+		footprint, err := h.caseFootprint(rc.CaseID)
+		if err != nil {
+			h.Inc(metricAdminErr)
+			log.Printf("error getting case storage: %v", err)
+			return
+		}
+		details = append(details, queries.RecurringCarveDetail{Recurring: rc, Footprint: footprint})
+	}
+	// Occurrences of the selected recurring carve
+	var selected *queries.RecurringCarveDetail
+	var grid RecurringGrid
+	if name, ok := vars["name"]; ok {
+		for i := range details {
+			if details[i].Recurring.Name != name {
+				continue
+			}
+			selected = &details[i]
+			runs, err := h.Queries.RecurringRuns(selected.Recurring.ID)
+			if err != nil {
+				h.Inc(metricAdminErr)
+				log.Printf("error getting occurrences: %v", err)
+				return
+			}
+			if grid, err = h.recurringGrid(selected.Recurring, runs); err != nil {
+				h.Inc(metricAdminErr)
+				log.Printf("error getting carves of occurrences: %v", err)
+				return
+			}
+		}
+	}
+	// Custom functions to handle formatting
+	funcMap := template.FuncMap{
+		"pastFutureTimes":         utils.PastFutureTimes,
+		"bytesReceivedConversion": func(b int64) string { return utils.BytesReceivedConversion(int(b)) },
+	}
+	// Prepare template
+	tempateFiles := h.NewTemplateFiles(h.TemplatesFolder, "carves-recurring.html").filepaths
+	t, err := template.New("carves-recurring.html").Funcs(funcMap).ParseFiles(tempateFiles...)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting recurring carves template: %v", err)
+		return
+	}
+	// Get all environments
+	envAll, err := h.Envs.AllCtx(r.Context())
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environments %v", err)
+		return
+	}
+	// Get all platforms
+	platforms, err := h.Nodes.GetAllPlatforms()
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting platforms: %v", err)
+		return
+	}
+	// Prepare template data
+	templateData := RecurringCarvesTemplateData{
+		Title:        "Recurring carves in " + env.Name,
+		EnvName:      env.Name,
+		EnvUUID:      env.UUID,
+		Recurring:    details,
+		Selected:     selected,
+		Grid:         grid,
+		Metadata:     h.EnvTemplateMetadata(ctx, h.ServiceVersion, env.UUID),
+		LeftMetadata: AsideLeftMetadata{EnvUUID: env.UUID},
+		Environments: h.allowedEnvironments(ctx[sessions.CtxUser], envAll),
+		Platforms:    platforms,
+	}
+	if err := t.Execute(w, templateData); err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("template error %v", err)
+		return
+	}
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Recurring carves template served")
+	}
+	h.Inc(metricAdminOK)
+}
+
+// RecurringCarvesPOSTHandler for POST requests to create, pause, resume and stop recurring carves
+func (h *HandlersAdmin) RecurringCarvesPOSTHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), true)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		h.Inc(metricAdminErr)
+		log.Println("error getting environment")
+		return
+	}
+	// Get environment
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environment: %v", err)
+		return
+	}
+	var c RecurringCarveRequest
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	user := ctx[sessions.CtxUser]
+	// Check permissions
+	if !h.Users.CheckPermissions(user, users.CarveLevel, env.UUID) {
+		adminErrorResponse(w, fmt.Sprintf("%s has insuficient permissions", user), http.StatusForbidden, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Parse request JSON body
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Decoding POST body")
+	}
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		adminErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Check CSRF Token
+	if !sessions.CheckCSRFToken(ctx[sessions.CtxCSRF], c.CSRFToken) {
+		adminErrorResponse(w, "invalid CSRF token", http.StatusInternalServerError, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	var msg string
+	switch c.Action {
+	case "create":
+		var endAt time.Time
+		if c.EndAt != "" {
+			if endAt, err = time.Parse(time.RFC3339, c.EndAt); err != nil {
+				adminErrorResponse(w, "invalid end date", http.StatusBadRequest, err)
+				h.Inc(metricAdminErr)
+				return
+			}
+		}
+		uuids := strings.FieldsFunc(c.UUIDs, func(r rune) bool { return r == ',' || r == '\n' || r == ' ' })
+		var rc queries.RecurringCarve
+		rc, err = h.Queries.NewRecurringCarve(env.ID, c.CaseID, strings.Split(c.Paths, "\n"), uuids, c.Recurrence, endAt, user)
+		msg = fmt.Sprintf("recurring carve %s created successfully", rc.Name)
+	case "pause":
+		err = h.Queries.PauseRecurringCarve(c.Name, env.ID)
+		msg = fmt.Sprintf("recurring carve %s paused successfully", c.Name)
+	case "resume":
+		err = h.Queries.ResumeRecurringCarve(c.Name, env.ID)
+		msg = fmt.Sprintf("recurring carve %s resumed successfully", c.Name)
+	case "stop":
+		err = h.Queries.StopRecurringCarve(c.Name, env.ID, user)
+		msg = fmt.Sprintf("recurring carve %s stopped successfully", c.Name)
+	default:
+		adminErrorResponse(w, "invalid action", http.StatusBadRequest, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	if err != nil {
+		adminErrorResponse(w, fmt.Sprintf("error with %s of recurring carve", c.Action), http.StatusBadRequest, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	log.Printf("%s by %s", msg, user)
+	adminOKResponse(w, msg)
+	// Serialize and send response
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Recurring carves response sent")
+	}
+	h.Inc(metricAdminOK)
+}
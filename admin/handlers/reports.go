@@ -0,0 +1,263 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/admin/sessions"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/reports"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+// ReportsGETHandler for GET requests for /reports
+func (h *HandlersAdmin) ReportsGETHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), false)
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Check permissions
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.AdminLevel, users.NoEnvironment) {
+		log.Printf("%s has insuficient permissions", ctx[sessions.CtxUser])
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Custom functions to handle formatting
+	funcMap := template.FuncMap{
+		"pastFutureTimes": utils.PastFutureTimes,
+		"inFutureTime":    utils.InFutureTime,
+	}
+	// Prepare template
+	tempateFiles := h.NewTemplateFiles(h.TemplatesFolder, "reports.html").filepaths
+	t, err := template.New("reports.html").Funcs(funcMap).ParseFiles(tempateFiles...)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting reports template: %v", err)
+		return
+	}
+	// Get stats for all environments
+	envAll, err := h.Envs.AllCtx(r.Context())
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environments %v", err)
+		return
+	}
+	// Get stats for all platforms
+	platforms, err := h.Nodes.GetAllPlatforms()
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting platforms: %v", err)
+		return
+	}
+	// Get generated reports and schedules
+	reportsAll, err := h.Reports.Gets(0)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting reports: %v", err)
+		return
+	}
+	schedules, err := h.Reports.GetSchedules()
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting report schedules: %v", err)
+		return
+	}
+	// Prepare template data
+	templateData := ReportsTemplateData{
+		Title:        "Fleet reports",
+		Metadata:     h.TemplateMetadata(ctx, h.ServiceVersion),
+		Environments: h.allowedEnvironments(ctx[sessions.CtxUser], envAll),
+		Platforms:    platforms,
+		Reports:      reportsAll,
+		Schedules:    schedules,
+		Categories:   environments.Categories,
+		PDFEnabled:   h.Reports.PDFConverter != "",
+		EmailEnabled: h.Reports.SMTP != nil,
+	}
+	if err := t.Execute(w, templateData); err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("template error %v", err)
+		return
+	}
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Reports template served")
+	}
+	h.Inc(metricAdminOK)
+}
+
+// ReportsPOSTHandler for POST requests for /reports
+func (h *HandlersAdmin) ReportsPOSTHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), true)
+	var rr ReportsRequest
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Check permissions
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.AdminLevel, users.NoEnvironment) {
+		adminErrorResponse(w, fmt.Sprintf("%s has insuficient permissions", ctx[sessions.CtxUser]), http.StatusForbidden, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Parse request JSON body
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Decoding POST body")
+	}
+	if err := json.NewDecoder(r.Body).Decode(&rr); err != nil {
+		adminErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Check CSRF Token
+	if !sessions.CheckCSRFToken(ctx[sessions.CtxCSRF], rr.CSRFToken) {
+		adminErrorResponse(w, "invalid CSRF token", http.StatusInternalServerError, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Reports for one environment or fleet-wide
+	var envID uint
+	envName := reports.FleetWide
+	var envLocale string
+	if rr.Action == "generate" || rr.Action == "schedule" {
+		if rr.Environment != "" && rr.Environment != reports.FleetWide {
+			env, err := h.Envs.GetCtx(r.Context(), rr.Environment)
+			if err != nil {
+				adminErrorResponse(w, "error getting environment", http.StatusInternalServerError, err)
+				h.Inc(metricAdminErr)
+				return
+			}
+			envID = env.ID
+			envName = env.Name
+			envLocale = env.Locale
+		}
+	}
+	switch rr.Action {
+	case "generate":
+		if !environments.IsCategory(rr.Category) {
+			adminErrorResponse(w, "invalid category", http.StatusBadRequest, fmt.Errorf("category %s", rr.Category))
+			h.Inc(metricAdminErr)
+			return
+		}
+		report, err := h.Reports.Generate(r.Context(), reports.ReportRequest{
+			EnvironmentID: envID,
+			Environment:   envName,
+			Category:      rr.Category,
+			Locale:        envLocale,
+			Period:        rr.Period,
+			Format:        rr.Format,
+			Creator:       ctx[sessions.CtxUser],
+			InactiveHours: h.Settings.InactiveHours(),
+		})
+		if err != nil {
+			adminErrorResponse(w, "error generating report", http.StatusInternalServerError, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		adminOKResponse(w, fmt.Sprintf("report %s generated successfully", report.Name))
+	case "delete":
+		if err := h.Reports.Delete(rr.ID); err != nil {
+			adminErrorResponse(w, "error deleting report", http.StatusInternalServerError, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		adminOKResponse(w, "report deleted successfully")
+	case "schedule":
+		var recipients []string
+		for _, rcpt := range strings.Split(rr.Recipients, ",") {
+			if rcpt = strings.TrimSpace(rcpt); rcpt != "" {
+				recipients = append(recipients, rcpt)
+			}
+		}
+		if _, err := h.Reports.NewSchedule(envID, envName, rr.Frequency, rr.Format, recipients, ctx[sessions.CtxUser]); err != nil {
+			adminErrorResponse(w, "error scheduling report", http.StatusInternalServerError, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		adminOKResponse(w, "report scheduled successfully")
+	case "unschedule":
+		if err := h.Reports.DeleteSchedule(rr.ID); err != nil {
+			adminErrorResponse(w, "error deleting report schedule", http.StatusInternalServerError, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		adminOKResponse(w, "report schedule deleted successfully")
+	default:
+		adminErrorResponse(w, "invalid action", http.StatusBadRequest, fmt.Errorf("invalid action %s", rr.Action))
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Serialize and send response
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Reports response sent")
+	}
+	h.Inc(metricAdminOK)
+}
+
+// ReportsDownloadHandler for GET requests to download reports
+func (h *HandlersAdmin) ReportsDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), false)
+	vars := mux.Vars(r)
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Check permissions
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.AdminLevel, users.NoEnvironment) {
+		log.Printf("%s has insuficient permissions", ctx[sessions.CtxUser])
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Extract id to download
+	idVar, ok := vars["id"]
+	if !ok {
+		h.Inc(metricAdminErr)
+		log.Println("error getting report")
+		return
+	}
+	id, err := strconv.ParseUint(idVar, 10, 64)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("invalid report id %s", idVar)
+		return
+	}
+	report, err := h.Reports.Get(uint(id))
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting report %v", err)
+		return
+	}
+	// Reports in S3 are downloaded directly from the bucket
+	if report.Storage == reports.StorageS3 {
+		if h.Reports.S3 == nil {
+			h.Inc(metricAdminErr)
+			log.Printf("report %s is in S3, but S3 is not configured", report.Name)
+			return
+		}
+		link, err := h.Reports.S3.GetDownloadLink(r.Context(), report.Location)
+		if err != nil {
+			h.Inc(metricAdminErr)
+			log.Printf("error getting report link %v", err)
+			return
+		}
+		http.Redirect(w, r, link, http.StatusFound)
+		h.Inc(metricAdminOK)
+		return
+	}
+	// Prepare response with the report content
+	w.Header().Set("Content-Type", reports.ContentType(report.Format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", report.Name))
+	w.Header().Set("Content-Length", strconv.FormatInt(int64(len(report.Content)), 10))
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(report.Content); err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error sending report %v", err)
+		return
+	}
+	h.Inc(metricAdminOK)
+}
@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/admin/sessions"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/responses"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+// Number of recorded response actions shown
+const responseActionsShown = 100
+
+// ResponsesGETHandler for GET requests for /responses/{environment}
+func (h *HandlersAdmin) ResponsesGETHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), false)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["environment"]
+	if !ok {
+		h.Inc(metricAdminErr)
+		log.Println("error getting environment")
+		return
+	}
+	// Get environment
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environment: %v", err)
+		return
+	}
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Check permissions
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.UserLevel, env.UUID) {
+		log.Printf("%s has insuficient permissions", ctx[sessions.CtxUser])
+		h.Inc(metricAdminErr)
+		return
+	}
+	cfg, err := h.Responses.Config(env.ID)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting response actions configuration: %v", err)
+		return
+	}
+	triggers, err := h.Responses.Triggers(env.ID)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting response triggers: %v", err)
+		return
+	}
+	actions, err := h.Responses.Actions(env.ID, responseActionsShown)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting response actions: %v", err)
+		return
+	}
+	mutes, err := h.Responses.Mutes(env.ID, time.Now())
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting mutes: %v", err)
+		return
+	}
+	qTemplates, err := h.Queries.GetTemplates()
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting query templates: %v", err)
+		return
+	}
+	// Custom functions to handle formatting
+	funcMap := template.FuncMap{
+		"pastFutureTimes": utils.PastFutureTimes,
+		"isAllowed":       responses.IsAllowed,
+	}
+	// Prepare template
+	tempateFiles := h.NewTemplateFiles(h.TemplatesFolder, "responses.html").filepaths
+	t, err := template.New("responses.html").Funcs(funcMap).ParseFiles(tempateFiles...)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting responses template: %v", err)
+		return
+	}
+	// Get stats for all environments
+	envAll, err := h.Envs.AllCtx(r.Context())
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environments %v", err)
+		return
+	}
+	// Get stats for all platforms
+	platforms, err := h.Nodes.GetAllPlatforms()
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting platforms: %v", err)
+		return
+	}
+	// Prepare template data
+	templateData := ResponsesTemplateData{
+		Title:        env.Name + " Response Actions",
+		EnvName:      env.Name,
+		EnvUUID:      env.UUID,
+		Config:       cfg,
+		Kinds:        responses.Kinds,
+		Triggers:     triggers,
+		Actions:      actions,
+		Templates:    qTemplates,
+		Mutes:        mutes,
+		MuteKinds:    responses.MuteKinds,
+		Suppressed:   responses.SuppressedTally(mutes),
+		Now:          time.Now(),
+		Metadata:     h.EnvTemplateMetadata(ctx, h.ServiceVersion, env.UUID),
+		LeftMetadata: AsideLeftMetadata{EnvUUID: env.UUID},
+		Environments: h.allowedEnvironments(ctx[sessions.CtxUser], envAll),
+		Platforms:    platforms,
+	}
+	if err := t.Execute(w, templateData); err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("template error %v", err)
+		return
+	}
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Responses template served")
+	}
+	h.Inc(metricAdminOK)
+}
+
+// ResponsesPOSTHandler for POST requests to change the response actions of an environment
+func (h *HandlersAdmin) ResponsesPOSTHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), true)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["environment"]
+	if !ok {
+		h.Inc(metricAdminErr)
+		log.Println("error getting environment")
+		return
+	}
+	// Get environment
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environment: %v", err)
+		return
+	}
+	var f ResponsesRequest
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Parse request JSON body
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Decoding POST body")
+	}
+	if err := json.NewDecoder(r.Body).Decode(&f); err != nil {
+		adminErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Check permissions, timed mutes only need query access and permanent mutes need admin access
+	level := users.AdminLevel
+	if (f.Action == "mute" && !f.Permanent) || f.Action == "unmute" {
+		level = users.QueryLevel
+	}
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], level, env.UUID) {
+		adminErrorResponse(w, fmt.Sprintf("%s has insuficient permissions", ctx[sessions.CtxUser]), http.StatusForbidden, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Check CSRF Token
+	if !sessions.CheckCSRFToken(ctx[sessions.CtxCSRF], f.CSRFToken) {
+		adminErrorResponse(w, "invalid CSRF token", http.StatusInternalServerError, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	switch f.Action {
+	case "config":
+		before, err := h.Responses.Config(env.ID)
+		if err != nil {
+			adminErrorResponse(w, "error getting configuration", http.StatusInternalServerError, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		after, err := h.Responses.SetConfig(env.ID, responses.ResponseConfig{
+			Enabled:    f.Enabled,
+			Allowed:    f.Allowed,
+			Cooldown:   f.Cooldown,
+			MaxPerHour: f.MaxPerHour,
+		})
+		if err != nil {
+			adminErrorResponse(w, "error saving configuration", http.StatusBadRequest, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		h.recordActivity(env, environments.ActivityResponses, ctx[sessions.CtxUser], "response actions configuration saved", responses.SerializeConfig(before), responses.SerializeConfig(after))
+		adminOKResponse(w, "configuration saved successfully")
+	case "reset":
+		if err := h.Responses.ResetCircuit(env.ID); err != nil {
+			adminErrorResponse(w, "error resetting circuit breaker", http.StatusInternalServerError, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		h.recordActivity(env, environments.ActivityResponses, ctx[sessions.CtxUser], "response actions circuit breaker reset", "", "")
+		adminOKResponse(w, "circuit breaker reset successfully")
+	case "trigger":
+		before, err := h.Responses.Triggers(env.ID)
+		if err != nil {
+			adminErrorResponse(w, "error getting triggers", http.StatusInternalServerError, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		saved, err := h.Responses.SetTrigger(env.ID, responses.ResponseTrigger{
+			Name:      f.Name,
+			Query:     f.Query,
+			Column:    f.Column,
+			Value:     f.Value,
+			Kind:      f.Kind,
+			Template:  f.Template,
+			Variables: f.Variables,
+			Path:      f.Path,
+			Enabled:   f.Enabled,
+			Creator:   ctx[sessions.CtxUser],
+		})
+		if err != nil {
+			adminErrorResponse(w, "error saving trigger", http.StatusBadRequest, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		after, err := h.Responses.Triggers(env.ID)
+		if err != nil {
+			log.Printf("error getting response triggers %v", err)
+		}
+		h.recordActivity(env, environments.ActivityResponses, ctx[sessions.CtxUser], "response trigger "+saved.Name+" saved", responses.SerializeTriggers(before), responses.SerializeTriggers(after))
+		adminOKResponse(w, "trigger saved successfully")
+	case "delete":
+		before, err := h.Responses.Triggers(env.ID)
+		if err != nil {
+			adminErrorResponse(w, "error getting triggers", http.StatusInternalServerError, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		if err := h.Responses.DeleteTrigger(env.ID, f.Name); err != nil {
+			adminErrorResponse(w, "error deleting trigger", http.StatusInternalServerError, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		after, err := h.Responses.Triggers(env.ID)
+		if err != nil {
+			log.Printf("error getting response triggers %v", err)
+		}
+		h.recordActivity(env, environments.ActivityResponses, ctx[sessions.CtxUser], "response trigger "+f.Name+" deleted", responses.SerializeTriggers(before), responses.SerializeTriggers(after))
+		adminOKResponse(w, "trigger deleted successfully")
+	case "mute":
+		now := time.Now()
+		mute := responses.Mute{
+			EnvironmentID: env.ID,
+			NodeUUID:      f.UUID,
+			Kind:          f.MuteKind,
+			Rule:          f.Rule,
+			Reason:        f.Reason,
+			Creator:       ctx[sessions.CtxUser],
+			Permanent:     f.Permanent,
+			NotifyExpiry:  f.Notify && !f.Permanent,
+		}
+		if !f.Permanent {
+			mute.ExpiresAt = now.Add(time.Duration(f.Hours) * time.Hour)
+		}
+		saved, err := h.Responses.SetMute(mute, now)
+		if err != nil {
+			adminErrorResponse(w, "error saving mute", http.StatusBadRequest, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		h.recordActivity(env, environments.ActivityMutes, ctx[sessions.CtxUser], "muted "+saved.Scope()+": "+saved.Reason, "", "")
+		adminOKResponse(w, "mute saved successfully")
+	case "unmute":
+		lifted, err := h.Responses.DeleteMute(env.ID, f.MuteID)
+		if err != nil {
+			adminErrorResponse(w, "error deleting mute", http.StatusInternalServerError, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		h.recordActivity(env, environments.ActivityMutes, ctx[sessions.CtxUser], "unmuted "+lifted.Scope(), "", "")
+		adminOKResponse(w, "mute deleted successfully")
+	default:
+		adminErrorResponse(w, "invalid action", http.StatusBadRequest, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Serialize and send response
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Responses response sent")
+	}
+	h.Inc(metricAdminOK)
+}
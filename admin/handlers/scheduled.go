@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/admin/sessions"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+// Helper to schedule the change of the configuration sent in a request, instead of saving it now
+func (h *HandlersAdmin) scheduleConfigChange(w http.ResponseWriter, env environments.TLSEnvironment, c ConfigurationRequest, user string) {
+	section, value, err := configurationSection(c)
+	if err != nil {
+		adminErrorResponse(w, err.Error(), http.StatusBadRequest, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	if section == "" {
+		adminErrorResponse(w, "no configuration to schedule", http.StatusBadRequest, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Check conflicts before scheduling, the change is applied without asking again
+	warnings, err := h.configConflicts(env, section, value)
+	if err != nil {
+		adminErrorResponse(w, err.Error(), http.StatusBadRequest, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	change, err := h.Envs.ScheduleConfigChange(env, section, value, c.ApplyAt, user, c.Note, c.Replace, time.Now())
+	if errors.Is(err, environments.ErrPendingChange) {
+		adminErrorResponse(w, "a change is already scheduled, replace it or cancel it first", http.StatusConflict, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	if err != nil {
+		adminErrorResponse(w, "error scheduling change", http.StatusBadRequest, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Send response
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Scheduled change response sent")
+	}
+	msg := fmt.Sprintf("%s change scheduled for %s", section, change.ApplyAt.UTC().Format(environments.ScheduledTimeFormat))
+	adminOKResponse(w, conflictsMessage(msg, warnings))
+	h.Inc(metricAdminOK)
+}
+
+// ConfScheduledPOSTHandler for POST requests to cancel the scheduled change of the configuration
+func (h *HandlersAdmin) ConfScheduledPOSTHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), true)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["environment"]
+	if !ok {
+		h.Inc(metricAdminErr)
+		log.Println("error getting environment")
+		return
+	}
+	// Get environment
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environment: %v", err)
+		return
+	}
+	var s ScheduledRequest
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Check permissions
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.AdminLevel, env.UUID) {
+		adminErrorResponse(w, fmt.Sprintf("%s has insuficient permissions", ctx[sessions.CtxUser]), http.StatusForbidden, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Parse request JSON body
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Decoding POST body")
+	}
+	if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+		adminErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Check CSRF Token
+	if !sessions.CheckCSRFToken(ctx[sessions.CtxCSRF], s.CSRFToken) {
+		adminErrorResponse(w, "invalid CSRF token", http.StatusInternalServerError, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	change, err := h.Envs.CancelConfigChange(env.ID, ctx[sessions.CtxUser], time.Now())
+	if err != nil {
+		adminErrorResponse(w, "error cancelling scheduled change", http.StatusBadRequest, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	adminOKResponse(w, fmt.Sprintf("%s change scheduled for %s cancelled", change.Section, change.ApplyAt.UTC().Format(environments.ScheduledTimeFormat)))
+	// Serialize and send response
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Scheduled change response sent")
+	}
+	h.Inc(metricAdminOK)
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/jmpsec/osctrl/admin/sessions"
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+// SearchGETHandler for GET requests for /search to find nodes across the environments of the user
+func (h *HandlersAdmin) SearchGETHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), false)
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Prepare template
+	tempateFiles := h.NewTemplateFiles(h.TemplatesFolder, "search.html").filepaths
+	t, err := template.ParseFiles(tempateFiles...)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting search template: %v", err)
+		return
+	}
+	// Get stats for all environments
+	envAll, err := h.Envs.AllCtx(r.Context())
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environments %v", err)
+		return
+	}
+	// Get stats for all platforms
+	platforms, err := h.Nodes.GetAllPlatforms()
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting platforms: %v", err)
+		return
+	}
+	// Only environments with access are searched
+	allowed := h.allowedEnvironments(ctx[sessions.CtxUser], envAll)
+	envIDs := make([]uint, 0, len(allowed))
+	envUUIDs := make(map[uint]string)
+	for _, e := range allowed {
+		envIDs = append(envIDs, e.ID)
+		envUUIDs[e.ID] = e.UUID
+	}
+	term := strings.TrimSpace(r.URL.Query().Get("q"))
+	var matches []nodes.NodeMatch
+	var searchErr string
+	if term != "" {
+		matches, err = h.Nodes.Search(r.Context(), term, envIDs, nodes.DefaultSearchLimit)
+		if err != nil {
+			log.Printf("error searching nodes: %v", err)
+			searchErr = err.Error()
+		}
+	}
+	// Prepare template data
+	templateData := SearchTemplateData{
+		Title:        "Find nodes",
+		Term:         term,
+		Error:        searchErr,
+		Matches:      matches,
+		EnvUUIDs:     envUUIDs,
+		Metadata:     h.TemplateMetadata(ctx, h.ServiceVersion),
+		Environments: allowed,
+		Platforms:    platforms,
+	}
+	if err := t.Execute(w, templateData); err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("template error %v", err)
+		return
+	}
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Search template served")
+	}
+	h.Inc(metricAdminOK)
+}
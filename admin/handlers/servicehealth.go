@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+
+	"github.com/jmpsec/osctrl/admin/sessions"
+	"github.com/jmpsec/osctrl/metrics"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+// Routes displayed in the top errors and the slowest tables
+const serviceHealthTop = 10
+
+// ServiceHealthGETHandler for GET requests for /service-health with the request statistics of osctrl-tls
+func (h *HandlersAdmin) ServiceHealthGETHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), false)
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Check permissions
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.AdminLevel, users.NoEnvironment) {
+		log.Printf("%s has insuficient permissions", ctx[sessions.CtxUser])
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Custom functions to handle formatting
+	funcMap := template.FuncMap{
+		"percent": func(rate float64) string {
+			return fmt.Sprintf("%.2f%%", rate*100)
+		},
+	}
+	// Prepare template
+	tempateFiles := h.NewTemplateFiles(h.TemplatesFolder, "service-health.html").filepaths
+	t, err := template.New("service-health.html").Funcs(funcMap).ParseFiles(tempateFiles...)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting service health template: %v", err)
+		return
+	}
+	window := r.URL.Query().Get("window")
+	if window == "" {
+		window = metrics.RequestWindow1h
+	}
+	// Every instance of osctrl-tls publishes its own statistics
+	snapshots, err := h.RedisCache.GetRequestStats()
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting request stats: %v", err)
+		return
+	}
+	rows := metrics.InstanceRows(snapshots, window)
+	// Prepare template data
+	templateData := ServiceHealthTemplateData{
+		Title:        "Service health",
+		Window:       window,
+		Windows:      metrics.RequestWindows,
+		Instances:    snapshots,
+		TopErrors:    metrics.TopErrorRoutes(rows, serviceHealthTop),
+		Slowest:      metrics.SlowestRoutes(rows, serviceHealthTop),
+		Environments: metrics.EnvironmentRows(rows),
+		Routes:       rows,
+		Metadata:     h.TemplateMetadata(ctx, h.ServiceVersion),
+	}
+	if err := t.Execute(w, templateData); err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("template error %v", err)
+		return
+	}
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Service health template served")
+	}
+	h.Inc(metricAdminOK)
+}
@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jmpsec/osctrl/cache"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+const (
+	// SetupTokenHeader to provide the setup token for automated installs
+	SetupTokenHeader string = "X-Setup-Token"
+)
+
+// Only one setup request can run at a time
+var setupMutex sync.Mutex
+
+// SetupGETHandler for the first-run setup wizard, only available while setup is pending
+func (h *HandlersAdmin) SetupGETHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), false)
+	pending, err := h.Users.SetupPending()
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error checking setup %v", err)
+		http.Error(w, "error checking setup", http.StatusInternalServerError)
+		return
+	}
+	if !pending {
+		http.Redirect(w, r, h.URLPrefix+"/login", http.StatusFound)
+		h.Inc(metricAdminOK)
+		return
+	}
+	state, err := h.Users.GetSetup()
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting setup %v", err)
+		return
+	}
+	templates, err := h.Envs.Templates()
+	if err != nil {
+		log.Printf("error getting environment templates %v", err)
+	}
+	// Prepare template
+	t, err := template.ParseFiles(
+		h.TemplatesFolder+"/setup.html",
+		h.TemplatesFolder+"/components/page-head-"+h.StaticLocation+".html",
+		h.TemplatesFolder+"/components/page-js-"+h.StaticLocation+".html")
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting setup template: %v", err)
+		return
+	}
+	// Prepare template data
+	templateData := SetupTemplateData{
+		Title:         "Setup osctrl",
+		Project:       "osctrl",
+		Token:         r.URL.Query().Get("token"),
+		State:         state,
+		Templates:     templates,
+		InactiveHours: h.Settings.InactiveHours(),
+		MinPassword:   users.MinPasswordLength,
+		Metadata:      TemplateMetadata{URLPrefix: h.URLPrefix},
+	}
+	if err := t.Execute(w, templateData); err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("template error %v", err)
+		return
+	}
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Setup template served")
+	}
+	h.Inc(metricAdminOK)
+}
+
+// SetupPOSTHandler for POST requests to run the first-run setup, from the wizard or from automated installs
+func (h *HandlersAdmin) SetupPOSTHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), false)
+	setupMutex.Lock()
+	defer setupMutex.Unlock()
+	pending, err := h.Users.SetupPending()
+	if err != nil {
+		adminErrorResponse(w, "error checking setup", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	if !pending {
+		adminErrorResponse(w, "setup is not available", http.StatusNotFound, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	var s SetupRequest
+	// Parse request JSON body
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Decoding POST body")
+	}
+	if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+		adminErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	if s.Token == "" {
+		s.Token = r.Header.Get(SetupTokenHeader)
+	}
+	if !users.CheckSetupToken(h.SetupToken, s.Token) {
+		adminErrorResponse(w, "invalid setup token", http.StatusForbidden, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Verify fields
+	if s.Username == "" || s.Environment == "" || s.Hostname == "" {
+		adminErrorResponse(w, "username, environment and hostname are required", http.StatusBadRequest, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	if err := users.CheckPasswordPolicy(s.Username, s.Password); err != nil {
+		adminErrorResponse(w, err.Error(), http.StatusBadRequest, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	if s.InactiveHours <= 0 {
+		s.InactiveHours = h.Settings.InactiveHours()
+	}
+	state, err := h.Users.GetSetup()
+	if err != nil {
+		adminErrorResponse(w, "error getting setup", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	if err := state.Resume(s.Username, s.Environment); err != nil {
+		adminErrorResponse(w, err.Error(), http.StatusConflict, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	if state.Status == "" {
+		state = users.SetupState{
+			Status:      users.SetupStarted,
+			Username:    s.Username,
+			Environment: s.Environment,
+			StartedAt:   time.Now(),
+		}
+		if err := h.Users.SaveSetup(&state); err != nil {
+			adminErrorResponse(w, "error saving setup", http.StatusInternalServerError, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+	} else {
+		log.Printf("Resuming setup for %s, completed steps: %s", state.Username, state.Steps)
+	}
+	env, err := h.setupSteps(&state, s)
+	if err != nil {
+		adminErrorResponse(w, "error running setup", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	state.Status = users.SetupCompleted
+	state.CompletedAt = time.Now()
+	if err := h.Users.SaveSetup(&state); err != nil {
+		adminErrorResponse(w, "error saving setup", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	h.publishEvent(cache.EventEnvironment, env.UUID, "create")
+	log.Printf("Setup completed by %s with environment %s", s.Username, env.Name)
+	// Serialize and send response
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Setup response sent")
+	}
+	adminOKResponse(w, h.URLPrefix+"/login")
+	h.Inc(metricAdminOK)
+}
+
+// Helper to run each step of the setup, steps are idempotent and saved as they complete so setup can be resumed
+func (h *HandlersAdmin) setupSteps(state *users.SetupState, s SetupRequest) (environments.TLSEnvironment, error) {
+	var env environments.TLSEnvironment
+	// First admin user, it may exist from an interrupted setup and it gets the password of this request
+	if h.Users.Exists(s.Username) {
+		if err := h.Users.ChangePassword(s.Username, s.Password); err != nil {
+			return env, fmt.Errorf("error changing password - %v", err)
+		}
+		if err := h.Users.ChangeAdmin(s.Username, true); err != nil {
+			return env, fmt.Errorf("error changing admin - %v", err)
+		}
+	} else {
+		user, err := h.Users.New(s.Username, s.Password, s.Email, s.Fullname, "", true)
+		if err != nil {
+			return env, fmt.Errorf("error with new user - %v", err)
+		}
+		if err := h.Users.Create(user); err != nil {
+			return env, fmt.Errorf("error creating user - %v", err)
+		}
+	}
+	state.Complete(users.SetupStepUser)
+	if err := h.Users.SaveSetup(state); err != nil {
+		return env, err
+	}
+	// First environment, with full permissions for the admin user
+	if h.Envs.Exists(s.Environment) {
+		existing, err := h.Envs.Get(s.Environment)
+		if err != nil {
+			return env, fmt.Errorf("error getting environment - %v", err)
+		}
+		env = existing
+	} else {
+		created, err := h.createEnvironment(s.Environment, s.Hostname, s.Template, environments.DefaultEnvironmentIcon, environments.DefaultEnvironmentType, s.Username)
+		if err != nil {
+			return env, err
+		}
+		env = created
+	}
+	if !state.Done(users.SetupStepEnvironment) {
+		if !h.Tags.Exists(env.Name) {
+			if err := h.Tags.NewTag(env.Name, "Tag for environment "+env.Name, "", env.Icon, s.Username); err != nil {
+				return env, fmt.Errorf("error generating tag - %v", err)
+			}
+		}
+		if err := h.Users.DeletePermissions(s.Username, env.UUID); err != nil {
+			return env, fmt.Errorf("error deleting permissions - %v", err)
+		}
+		access := h.Users.GenEnvUserAccess([]string{env.UUID}, true, true, true, true)
+		perms := h.Users.GenPermissions(s.Username, "osctrl-admin", access)
+		if err := h.Users.CreatePermissions(perms); err != nil {
+			return env, fmt.Errorf("error generating permissions - %v", err)
+		}
+		if err := h.Users.ChangeDefaultEnv(s.Username, env.UUID); err != nil {
+			return env, fmt.Errorf("error changing default environment - %v", err)
+		}
+		state.Complete(users.SetupStepEnvironment)
+		if err := h.Users.SaveSetup(state); err != nil {
+			return env, err
+		}
+	}
+	// Basic settings
+	if !state.Done(users.SetupStepSettings) {
+		if err := h.Settings.SetString(env.Name, settings.ServiceAdmin, settings.DefaultEnv, false); err != nil {
+			return env, fmt.Errorf("error setting default environment - %v", err)
+		}
+		if err := h.Settings.SetInteger(s.InactiveHours, settings.ServiceAdmin, settings.InactiveHours); err != nil {
+			return env, fmt.Errorf("error setting inactive hours - %v", err)
+		}
+		state.Complete(users.SetupStepSettings)
+		if err := h.Users.SaveSetup(state); err != nil {
+			return env, err
+		}
+	}
+	return env, nil
+}
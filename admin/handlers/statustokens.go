@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/admin/sessions"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+const (
+	metricStatusReq = "admin-status-req"
+	metricStatusErr = "admin-status-err"
+	metricStatusOK  = "admin-status-ok"
+)
+
+// StatusTokenKey to get the key to sign and verify node status tokens, from the secret shared with osctrl-api
+func (h *HandlersAdmin) StatusTokenKey() []byte {
+	return nodes.StatusTokenKey(h.StatusSecret)
+}
+
+// Helper to get the maximum lifetime of node status tokens, zero when they are disabled
+func (h *HandlersAdmin) statusTokenLifetime() time.Duration {
+	if !h.Settings.StatusTokens() {
+		return 0
+	}
+	return time.Duration(h.Settings.StatusTokenMaxHours()) * time.Hour
+}
+
+// StatusTokenPOSTHandler for POST requests to generate a status token for one node
+func (h *HandlersAdmin) StatusTokenPOSTHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), true)
+	var s StatusTokenRequest
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Parse request JSON body
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Decoding POST body")
+	}
+	if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+		adminErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Check CSRF Token
+	if !sessions.CheckCSRFToken(ctx[sessions.CtxCSRF], s.CSRFToken) {
+		adminErrorResponse(w, "invalid CSRF token", http.StatusInternalServerError, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	maxLifetime := h.statusTokenLifetime()
+	if maxLifetime <= 0 {
+		adminErrorResponse(w, "status tokens are disabled", http.StatusBadRequest, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	if h.StatusTokenKey() == nil {
+		adminErrorResponse(w, "status tokens need a secret", http.StatusBadRequest, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Get node and environment
+	node, err := h.Nodes.GetByUUID(s.UUID)
+	if err != nil {
+		adminErrorResponse(w, "error getting node", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	env, err := h.Envs.GetCtx(r.Context(), node.Environment)
+	if err != nil {
+		adminErrorResponse(w, "error getting environment", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Check permissions, status tokens share the node status without authentication
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.AdminLevel, env.UUID) {
+		adminErrorResponse(w, fmt.Sprintf("%s has insuficient permissions", ctx[sessions.CtxUser]), http.StatusForbidden, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Tokens never live longer than the maximum allowed
+	lifetime := time.Duration(s.Hours) * time.Hour
+	if lifetime <= 0 || lifetime > maxLifetime {
+		lifetime = maxLifetime
+	}
+	now := time.Now()
+	token, err := nodes.NewStatusToken(h.StatusTokenKey(), env.UUID, node.UUID, now, now.Add(lifetime))
+	if err != nil {
+		adminErrorResponse(w, "error generating status token", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	expires := nodes.StatusTokenExpiration(token)
+	h.recordActivity(env, environments.ActivityStatusTokens, ctx[sessions.CtxUser], fmt.Sprintf("status token for node %s (%s) generated, expires %s", node.Hostname, node.UUID, expires.UTC().Format(time.RFC3339)), "", "")
+	response := StatusTokenResponse{
+		Token:        token,
+		Path:         h.URLPrefix + nodes.StatusTokenPath + token,
+		Expiration:   utils.PastFutureTimes(expires),
+		ExpirationTS: utils.TimeTimestamp(expires),
+	}
+	// Serialize and serve JSON
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Printf("DebugService: Status token generated for %s", node.UUID)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, response)
+	h.Inc(metricAdminOK)
+}
+
+// StatusHandler for GET requests to show the status of one node with a status token.
+// Disabled, forged, expired and unknown tokens all get the same not found response.
+func (h *HandlersAdmin) StatusHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricStatusReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), false)
+	// No caching and no referrer, the URL is the credential
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	status, ok := h.nodeStatus(r)
+	if !ok {
+		http.NotFound(w, r)
+		h.Inc(metricStatusErr)
+		return
+	}
+	if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), utils.JSONApplication) {
+		utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, status)
+		h.Inc(metricStatusOK)
+		return
+	}
+	// Custom functions to handle formatting
+	funcMap := template.FuncMap{
+		"pastFutureTimes": utils.PastFutureTimes,
+	}
+	t, err := template.New("status.html").Funcs(funcMap).ParseFiles(
+		h.TemplatesFolder+"/status.html",
+		h.TemplatesFolder+"/components/page-head-"+h.StaticLocation+".html")
+	if err != nil {
+		log.Printf("error getting status template: %v", err)
+		http.NotFound(w, r)
+		h.Inc(metricStatusErr)
+		return
+	}
+	templateData := StatusTemplateData{
+		Title:    "Status of " + status.Hostname,
+		Status:   status,
+		Metadata: TemplateMetadata{URLPrefix: h.URLPrefix},
+	}
+	if err := t.Execute(w, templateData); err != nil {
+		log.Printf("template error %v", err)
+		h.Inc(metricStatusErr)
+		return
+	}
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Status template served")
+	}
+	h.Inc(metricStatusOK)
+}
+
+// Helper to get the status of the node of a valid status token
+func (h *HandlersAdmin) nodeStatus(r *http.Request) (nodes.NodeStatus, bool) {
+	maxLifetime := h.statusTokenLifetime()
+	if maxLifetime <= 0 {
+		return nodes.NodeStatus{}, false
+	}
+	envUUID, nodeUUID, ok := nodes.CheckStatusToken(h.StatusTokenKey(), mux.Vars(r)["token"], time.Now(), maxLifetime)
+	if !ok {
+		return nodes.NodeStatus{}, false
+	}
+	env, err := h.Envs.GetCtx(r.Context(), envUUID)
+	if err != nil {
+		return nodes.NodeStatus{}, false
+	}
+	node, err := h.Nodes.GetByUUIDEnv(nodeUUID, env.ID)
+	if err != nil {
+		return nodes.NodeStatus{}, false
+	}
+	status := nodes.StatusOfNode(node, env.Name, env.UpdatedAt, h.Settings.InactiveHours())
+	if d, err := h.Nodes.GetDecommission(node.UUID); err == nil && d.State != "" {
+		status.Enrollment = nodes.StatusDecommissioned
+	}
+	status.ExpiresAt = nodes.StatusTokenExpiration(mux.Vars(r)["token"])
+	return status, true
+}
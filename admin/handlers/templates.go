@@ -4,13 +4,19 @@ import (
 	"html/template"
 	"log"
 	"net/http"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/jmpsec/osctrl/admin/sessions"
 	"github.com/jmpsec/osctrl/carves"
 	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/locales"
+	"github.com/jmpsec/osctrl/logging"
 	"github.com/jmpsec/osctrl/nodes"
+	"github.com/jmpsec/osctrl/queries"
+	"github.com/jmpsec/osctrl/responses"
 	"github.com/jmpsec/osctrl/settings"
 	"github.com/jmpsec/osctrl/users"
 	"github.com/jmpsec/osctrl/utils"
@@ -24,17 +30,34 @@ type TemplateFiles struct {
 // TemplateMetadata - Helper to prepare template metadata
 // TODO until a better implementation, all users are admin
 func (h *HandlersAdmin) TemplateMetadata(ctx sessions.ContextValue, version string) TemplateMetadata {
+	return h.EnvTemplateMetadata(ctx, version, "")
+}
+
+// EnvTemplateMetadata - Helper to prepare template metadata for pages of an environment, with its notices
+func (h *HandlersAdmin) EnvTemplateMetadata(ctx sessions.ContextValue, version, envUUID string) TemplateMetadata {
+	notices, confirm := h.templateNotices(ctx[sessions.CtxUser], envUUID)
+	carveEnvs := h.carveEnvironments(ctx[sessions.CtxUser])
 	return TemplateMetadata{
 		Username:       ctx[sessions.CtxUser],
 		Level:          "admin",
 		CSRFToken:      ctx[sessions.CtxCSRF],
 		Service:        "osctrl-admin",
 		Version:        version,
+		Commit:         h.BuildInfo.ShortCommit(),
+		BuildDate:      h.BuildInfo.BuildDate,
 		TLSDebug:       h.Settings.DebugService(settings.ServiceTLS),
 		AdminDebug:     h.Settings.DebugService(settings.ServiceAdmin),
 		APIDebug:       h.Settings.DebugService(settings.ServiceAPI),
 		AdminDebugHTTP: h.Settings.DebugHTTP(settings.ServiceAdmin),
 		APIDebugHTTP:   h.Settings.DebugHTTP(settings.ServiceAPI),
+		URLPrefix:      h.URLPrefix,
+		EnvUUID:        envUUID,
+		Notices:        notices,
+		ConfirmNotices: confirm,
+		Quota:          h.storageQuota(envUUID),
+		EnvCategory:    h.envCategory(envUUID),
+		Carve:          carveEnvs[envUUID],
+		CarveEnvs:      carveEnvs,
 	}
 }
 
@@ -57,6 +80,14 @@ func (h *HandlersAdmin) NewTemplateFiles(base string, layoutFilename string) *Te
 func (h *HandlersAdmin) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	h.Inc(metricAdminReq)
 	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), false)
+	// Nobody can login until the first-run setup is completed
+	if h.SetupToken != "" {
+		if pending, err := h.Users.SetupPending(); err == nil && pending {
+			http.Redirect(w, r, h.URLPrefix+"/setup", http.StatusFound)
+			h.Inc(metricAdminOK)
+			return
+		}
+	}
 	// Prepare template
 	t, err := template.ParseFiles(
 		h.TemplatesFolder+"/login.html",
@@ -69,8 +100,9 @@ func (h *HandlersAdmin) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	// Prepare template data
 	templateData := LoginTemplateData{
-		Title:   "Login to osctrl",
-		Project: "osctrl",
+		Title:    "Login to osctrl",
+		Project:  "osctrl",
+		Metadata: TemplateMetadata{URLPrefix: h.URLPrefix},
 	}
 	if err := t.Execute(w, templateData); err != nil {
 		h.Inc(metricAdminErr)
@@ -96,7 +128,7 @@ func (h *HandlersAdmin) EnvironmentHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 	// Get environment
-	env, err := h.Envs.Get(envVar)
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
 	if err != nil {
 		h.Inc(metricAdminErr)
 		log.Printf("error getting environment: %v", err)
@@ -147,11 +179,18 @@ func (h *HandlersAdmin) EnvironmentHandler(w http.ResponseWriter, r *http.Reques
 		log.Printf("error getting platforms: %v", err)
 		return
 	}
+	// Get storm protection state, the page is served without banner if it fails
+	storm, err := h.Envs.GetStormState(env.ID)
+	if err != nil {
+		log.Printf("error getting storm state %v", err)
+	}
 	// Prepare template data
 	templateData := TableTemplateData{
 		Title:        "Nodes in " + env.Name,
+		Storm:        storm,
+		QuickActions: h.quickActionsFor(ctx[sessions.CtxUser], env),
 		EnvUUID:      env.UUID,
-		Metadata:     h.TemplateMetadata(ctx, h.ServiceVersion),
+		Metadata:     h.EnvTemplateMetadata(ctx, h.ServiceVersion, env.UUID),
 		Selector:     "environment",
 		SelectorName: env.Name,
 		Target:       target,
@@ -269,7 +308,7 @@ func (h *HandlersAdmin) QueryRunGETHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 	// Get environment
-	env, err := h.Envs.Get(envVar)
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
 	if err != nil {
 		h.Inc(metricAdminErr)
 		log.Printf("error getting environment: %v", err)
@@ -311,6 +350,15 @@ func (h *HandlersAdmin) QueryRunGETHandler(w http.ResponseWriter, r *http.Reques
 		log.Printf("error getting platforms: %v", err)
 		return
 	}
+	// Get platforms for this environment, used as targets
+	envPlatforms, err := h.Nodes.GetEnvPlatforms(env.ID)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting platforms: %v", err)
+		return
+	}
+	// Only offer the platforms allowed in the environment
+	envPlatforms = environments.FilterPlatforms(env.AllowedPlatforms, envPlatforms)
 	// Get all nodes
 	nodes, err := h.Nodes.Gets("active", h.Settings.InactiveHours())
 	if err != nil {
@@ -325,17 +373,40 @@ func (h *HandlersAdmin) QueryRunGETHandler(w http.ResponseWriter, r *http.Reques
 		uuids = append(uuids, n.UUID)
 		hosts = append(hosts, n.Localname)
 	}
+	// Get query templates to pick from
+	queryTemplates, err := h.queryTemplateViews(false)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting query templates: %v", err)
+		return
+	}
+	// Get concurrency groups to pick from
+	groups, err := h.Queries.GroupsUtilization(r.Context(), env.ID)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting query groups: %v", err)
+		return
+	}
+	var groupNames []string
+	for _, g := range groups {
+		groupNames = append(groupNames, g.Name)
+	}
 	// Prepare template data
 	templateData := QueryRunTemplateData{
-		Title:         "Query osquery Nodes in <b>" + env.Name + "</b>",
-		EnvUUID:       env.UUID,
-		Metadata:      h.TemplateMetadata(ctx, h.ServiceVersion),
-		Environments:  h.allowedEnvironments(ctx[sessions.CtxUser], envAll),
-		Platforms:     platforms,
-		UUIDs:         uuids,
-		Hosts:         hosts,
-		Tables:        h.OsqueryTables,
-		TablesVersion: h.OsqueryVersion,
+		Title:          "Query osquery Nodes in <b>" + env.Name + "</b>",
+		EnvUUID:        env.UUID,
+		Metadata:       h.EnvTemplateMetadata(ctx, h.ServiceVersion, env.UUID),
+		Environments:   h.allowedEnvironments(ctx[sessions.CtxUser], envAll),
+		Platforms:      platforms,
+		EnvPlatforms:   envPlatforms,
+		UUIDs:          uuids,
+		Hosts:          hosts,
+		Tables:         h.OsqueryTables,
+		TablesVersion:  h.OsqueryVersion,
+		QueryTemplates: queryTemplates,
+		MaxLabels:      queries.MaxQueryLabels,
+		Groups:         groupNames,
+		Expiration:     h.queryExpiration(env),
 	}
 	if err := t.Execute(w, templateData); err != nil {
 		h.Inc(metricAdminErr)
@@ -361,7 +432,7 @@ func (h *HandlersAdmin) QueryListGETHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 	// Get environment
-	env, err := h.Envs.Get(envVar)
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
 	if err != nil {
 		h.Inc(metricAdminErr)
 		log.Printf("error getting environment: %v", err)
@@ -401,7 +472,7 @@ func (h *HandlersAdmin) QueryListGETHandler(w http.ResponseWriter, r *http.Reque
 	templateData := QueryTableTemplateData{
 		Title:        "All on-demand queries",
 		EnvUUID:      env.UUID,
-		Metadata:     h.TemplateMetadata(ctx, h.ServiceVersion),
+		Metadata:     h.EnvTemplateMetadata(ctx, h.ServiceVersion, env.UUID),
 		Environments: h.allowedEnvironments(ctx[sessions.CtxUser], envAll),
 		Platforms:    platforms,
 		Target:       "all",
@@ -430,7 +501,7 @@ func (h *HandlersAdmin) SavedQueriesGETHandler(w http.ResponseWriter, r *http.Re
 		return
 	}
 	// Get environment
-	env, err := h.Envs.Get(envVar)
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
 	if err != nil {
 		h.Inc(metricAdminErr)
 		log.Printf("error getting environment: %v", err)
@@ -470,7 +541,7 @@ func (h *HandlersAdmin) SavedQueriesGETHandler(w http.ResponseWriter, r *http.Re
 	templateData := SavedQueriesTemplateData{
 		Title:        "Saved queries in <b>" + env.Name + "</b>",
 		EnvUUID:      env.UUID,
-		Metadata:     h.TemplateMetadata(ctx, h.ServiceVersion),
+		Metadata:     h.EnvTemplateMetadata(ctx, h.ServiceVersion, env.UUID),
 		Environments: h.allowedEnvironments(ctx[sessions.CtxUser], envAll),
 		Platforms:    platforms,
 		Target:       "saved",
@@ -499,7 +570,7 @@ func (h *HandlersAdmin) CarvesRunGETHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 	// Get environment
-	env, err := h.Envs.Get(envVar)
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
 	if err != nil {
 		h.Inc(metricAdminErr)
 		log.Printf("error getting environment: %v", err)
@@ -535,6 +606,15 @@ func (h *HandlersAdmin) CarvesRunGETHandler(w http.ResponseWriter, r *http.Reque
 		log.Printf("error getting platforms: %v", err)
 		return
 	}
+	// Get platforms for this environment, used as targets
+	envPlatforms, err := h.Nodes.GetEnvPlatforms(env.ID)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting platforms: %v", err)
+		return
+	}
+	// Only offer the platforms allowed in the environment
+	envPlatforms = environments.FilterPlatforms(env.AllowedPlatforms, envPlatforms)
 	// Get all nodes
 	nodes, err := h.Nodes.Gets("active", h.Settings.InactiveHours())
 	if err != nil {
@@ -553,9 +633,10 @@ func (h *HandlersAdmin) CarvesRunGETHandler(w http.ResponseWriter, r *http.Reque
 	templateData := CarvesRunTemplateData{
 		Title:         "Query osquery Nodes in <b>" + env.Name + "</b>",
 		EnvUUID:       env.UUID,
-		Metadata:      h.TemplateMetadata(ctx, h.ServiceVersion),
+		Metadata:      h.EnvTemplateMetadata(ctx, h.ServiceVersion, env.UUID),
 		Environments:  h.allowedEnvironments(ctx[sessions.CtxUser], envAll),
 		Platforms:     platforms,
+		EnvPlatforms:  envPlatforms,
 		UUIDs:         uuids,
 		Hosts:         hosts,
 		Tables:        h.OsqueryTables,
@@ -585,7 +666,7 @@ func (h *HandlersAdmin) CarvesListGETHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 	// Get environment
-	env, err := h.Envs.Get(envVar)
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
 	if err != nil {
 		h.Inc(metricAdminErr)
 		log.Printf("error getting environment: %v", err)
@@ -621,14 +702,30 @@ func (h *HandlersAdmin) CarvesListGETHandler(w http.ResponseWriter, r *http.Requ
 		log.Printf("error getting platforms: %v", err)
 		return
 	}
+	// Get use of carves against the limits of the environment
+	now := time.Now()
+	usage, err := h.Carves.Usage(env.ID, now)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting carve usage: %v", err)
+		return
+	}
+	rejections, err := h.Carves.Rejections(env.ID, usage.WindowStart)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting carve rejections: %v", err)
+		return
+	}
 	// Prepare template data
 	templateData := CarvesTableTemplateData{
 		Title:        "Carved files in <b>" + env.Name + "</b>",
 		EnvUUID:      env.UUID,
-		Metadata:     h.TemplateMetadata(ctx, h.ServiceVersion),
+		Metadata:     h.EnvTemplateMetadata(ctx, h.ServiceVersion, env.UUID),
 		Environments: h.allowedEnvironments(ctx[sessions.CtxUser], envAll),
 		Platforms:    platforms,
 		Target:       "all",
+		Usage:        usage,
+		Rejections:   rejections,
 	}
 	if err := t.Execute(w, templateData); err != nil {
 		h.Inc(metricAdminErr)
@@ -654,7 +751,7 @@ func (h *HandlersAdmin) QueryLogsHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	// Get environment
-	env, err := h.Envs.Get(envVar)
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
 	if err != nil {
 		h.Inc(metricAdminErr)
 		log.Printf("error getting environment: %v", err)
@@ -678,6 +775,10 @@ func (h *HandlersAdmin) QueryLogsHandler(w http.ResponseWriter, r *http.Request)
 	// Custom functions to handle formatting
 	funcMap := template.FuncMap{
 		"queryResultLink": h.queryResultLink,
+		"pastFutureTimes": utils.PastFutureTimes,
+		"exportSize": func(size int64) string {
+			return utils.BytesReceivedConversion(int(size))
+		},
 	}
 	// Prepare template
 	tempateFiles := h.NewTemplateFiles(h.TemplatesFolder, "queries-logs.html").filepaths
@@ -702,19 +803,67 @@ func (h *HandlersAdmin) QueryLogsHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	// Get query by name
-	query, err := h.Queries.Get(name, env.ID)
+	query, err := h.Queries.GetWithLabels(r.Context(), name, env.ID)
 	if err != nil {
 		h.Inc(metricAdminErr)
 		log.Printf("error getting query %v", err)
 		return
 	}
+	// Restricted queries show nothing but the status to who can not see them
+	if !h.canViewQuery(r.Context(), query, ctx[sessions.CtxUser]) {
+		h.serveLockedQuery(w, env, ctx, query)
+		return
+	}
 	// Get query targets
-	targets, err := h.Queries.GetTargets(name)
+	targets, err := h.Queries.GetTargetsCtx(r.Context(), name)
 	if err != nil {
 		h.Inc(metricAdminErr)
 		log.Printf("error getting targets %v", err)
 		return
 	}
+	// Get sharing exports with this query
+	shares, err := h.Queries.Shares(env.ID)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting sharing exports %v", err)
+		return
+	}
+	var queryShares []queries.ShareExport
+	for _, s := range shares {
+		for _, n := range s.QueryNames() {
+			if n == query.Name {
+				queryShares = append(queryShares, s)
+				break
+			}
+		}
+	}
+	// Get export jobs of the results of this query, users only see their own
+	exports, err := h.Queries.QueryExportJobs(query.Name, env.ID)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting export jobs %v", err)
+		return
+	}
+	isAdmin := h.Users.CheckPermissions(ctx[sessions.CtxUser], users.AdminLevel, env.UUID)
+	var queryExports []queries.ExportJob
+	for _, e := range exports {
+		if isAdmin || e.Creator == ctx[sessions.CtxUser] {
+			queryExports = append(queryExports, e)
+		}
+	}
+	// Archived queries show where the results are and the progress of the rehydration
+	var archive queries.QueryArchive
+	if query.Archived {
+		if archive, err = h.Queries.GetArchive(query.Name, env.ID); err != nil {
+			h.Inc(metricAdminErr)
+			log.Printf("error getting archive %v", err)
+			return
+		}
+	}
+	// Paused nodes keep the query pending until they are unpaused
+	if query.Paused, err = h.Queries.PausedTargets(query.Name, time.Now()); err != nil {
+		log.Printf("error getting paused nodes %v", err)
+	}
 	leftMetadata := AsideLeftMetadata{
 		EnvUUID:   env.UUID,
 		Query:     true,
@@ -724,12 +873,22 @@ func (h *HandlersAdmin) QueryLogsHandler(w http.ResponseWriter, r *http.Request)
 	templateData := QueryLogsTemplateData{
 		Title:        "Query logs " + query.Name,
 		EnvUUID:      env.UUID,
-		Metadata:     h.TemplateMetadata(ctx, h.ServiceVersion),
+		Metadata:     h.EnvTemplateMetadata(ctx, h.ServiceVersion, env.UUID),
 		LeftMetadata: leftMetadata,
 		Environments: h.allowedEnvironments(ctx[sessions.CtxUser], envAll),
 		Platforms:    platforms,
 		Query:        query,
 		QueryTargets: targets,
+		Window:       queries.WindowOfTargets(targets),
+		CanEdit:      query.Creator == ctx[sessions.CtxUser] || isAdmin,
+		Shares:       queryShares,
+		Exports:      queryExports,
+		Archive:      archive,
+		DenyColumns:  strings.Join(queries.DefaultShareDenyColumns, ", "),
+	}
+	// Administrators of the environment see who downloaded the results
+	if isAdmin {
+		templateData.Accesses = h.entityAccesses(env.ID, []string{environments.AccessQueryResult}, query.Name)
 	}
 	if err := t.Execute(w, templateData); err != nil {
 		h.Inc(metricAdminErr)
@@ -742,6 +901,114 @@ func (h *HandlersAdmin) QueryLogsHandler(w http.ResponseWriter, r *http.Request)
 	h.Inc(metricAdminOK)
 }
 
+// QueryProfileHandler for GET requests to see the report of a profile query
+func (h *HandlersAdmin) QueryProfileHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), false)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		h.Inc(metricAdminErr)
+		log.Println("error getting environment")
+		return
+	}
+	// Get environment
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environment: %v", err)
+		return
+	}
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Check permissions
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.QueryLevel, env.UUID) {
+		log.Printf("%s has insuficient permissions", ctx[sessions.CtxUser])
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Extract name
+	name, ok := vars["name"]
+	if !ok {
+		h.Inc(metricAdminErr)
+		log.Println("error getting name")
+		return
+	}
+	// Prepare template
+	tempateFiles := h.NewTemplateFiles(h.TemplatesFolder, "queries-profile.html").filepaths
+	t, err := template.ParseFiles(tempateFiles...)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting table template: %v", err)
+		return
+	}
+	// Get all environments
+	envAll, err := h.Envs.All()
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environments %v", err)
+		return
+	}
+	// Get all platforms
+	platforms, err := h.Nodes.GetAllPlatforms()
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting platforms: %v", err)
+		return
+	}
+	// Restricted profiles are locked to who can not see them
+	profile, err := h.Queries.GetCtx(r.Context(), name, env.ID)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting query %v", err)
+		return
+	}
+	if !h.canViewQuery(r.Context(), profile, ctx[sessions.CtxUser]) {
+		h.serveLockedQuery(w, env, ctx, profile)
+		return
+	}
+	// Get profile report
+	report, err := h.Queries.GetProfileReport(name, env.ID)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting profile %v", err)
+		return
+	}
+	// Get query targets
+	targets, err := h.Queries.GetTargetsCtx(r.Context(), name)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting targets %v", err)
+		return
+	}
+	leftMetadata := AsideLeftMetadata{
+		EnvUUID:   env.UUID,
+		Query:     true,
+		QueryName: report.Name,
+	}
+	// Prepare template data
+	templateData := QueryProfileTemplateData{
+		Title:        "Query profile " + report.Name,
+		EnvUUID:      env.UUID,
+		Metadata:     h.EnvTemplateMetadata(ctx, h.ServiceVersion, env.UUID),
+		LeftMetadata: leftMetadata,
+		Environments: h.allowedEnvironments(ctx[sessions.CtxUser], envAll),
+		Platforms:    platforms,
+		QueryTargets: targets,
+		Report:       report,
+	}
+	if err := t.Execute(w, templateData); err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("template error %v", err)
+		return
+	}
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Query profile template served")
+	}
+	h.Inc(metricAdminOK)
+}
+
 // CarvesDetailsHandler for GET requests to see carves details by name
 func (h *HandlersAdmin) CarvesDetailsHandler(w http.ResponseWriter, r *http.Request) {
 	h.Inc(metricAdminReq)
@@ -755,7 +1022,7 @@ func (h *HandlersAdmin) CarvesDetailsHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 	// Get environment
-	env, err := h.Envs.Get(envVar)
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
 	if err != nil {
 		log.Printf("error getting environment %s - %v", envVar, err)
 		h.Inc(metricAdminErr)
@@ -776,9 +1043,14 @@ func (h *HandlersAdmin) CarvesDetailsHandler(w http.ResponseWriter, r *http.Requ
 		log.Println("error getting name")
 		return
 	}
+	// Custom functions to handle formatting
+	funcMap := template.FuncMap{
+		"pastFutureTimes":         utils.PastFutureTimes,
+		"bytesReceivedConversion": func(b int64) string { return utils.BytesReceivedConversion(int(b)) },
+	}
 	// Prepare template
 	tempateFiles := h.NewTemplateFiles(h.TemplatesFolder, "carves-details.html").filepaths
-	t, err := template.ParseFiles(tempateFiles...)
+	t, err := template.New("carves-details.html").Funcs(funcMap).ParseFiles(tempateFiles...)
 	if err != nil {
 		h.Inc(metricAdminErr)
 		log.Printf("error getting table template: %v", err)
@@ -799,29 +1071,44 @@ func (h *HandlersAdmin) CarvesDetailsHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 	// Get query by name
-	query, err := h.Queries.Get(name, env.ID)
+	query, err := h.Queries.GetCtx(r.Context(), name, env.ID)
 	if err != nil {
 		h.Inc(metricAdminErr)
 		log.Printf("error getting query %v", err)
 		return
 	}
+	// Restricted carves show nothing but the status to who can not see them
+	if !h.canViewQuery(r.Context(), query, ctx[sessions.CtxUser]) {
+		h.serveLockedQuery(w, env, ctx, query)
+		return
+	}
 	// Get query targets
-	targets, err := h.Queries.GetTargets(name)
+	targets, err := h.Queries.GetTargetsCtx(r.Context(), name)
 	if err != nil {
 		h.Inc(metricAdminErr)
 		log.Printf("error getting targets %v", err)
 		return
 	}
 	// Get carves for this query
-	queryCarves, err := h.Carves.GetByQuery(name, env.ID)
+	queryCarves, err := h.Carves.GetByQueryCtx(r.Context(), name, env.ID)
 	if err != nil {
 		h.Inc(metricAdminErr)
 		log.Printf("error getting carve %v", err)
 		return
 	}
-	// Get carve blocks by carve
+	// Get carve blocks and download decision by carve
 	blocks := make(map[string][]carves.CarvedBlock)
+	downloads := make(map[string]string)
+	manifests := make(map[string][]carves.ManifestNode)
 	for _, c := range queryCarves {
+		downloads[c.SessionID] = carves.DownloadDecision(env.CarveScanPolicy, c.ScanVerdict)
+		if c.ManifestStatus == carves.ManifestReady {
+			entries, err := c.Entries()
+			if err != nil {
+				log.Printf("error getting carve manifest %v", err)
+			}
+			manifests[c.SessionID] = carves.ManifestTree(entries)
+		}
 		bs, err := h.Carves.GetBlocks(c.SessionID)
 		if err != nil {
 			h.Inc(metricAdminErr)
@@ -830,6 +1117,10 @@ func (h *HandlersAdmin) CarvesDetailsHandler(w http.ResponseWriter, r *http.Requ
 		}
 		blocks[c.SessionID] = bs
 	}
+	// Paused nodes do not carve until they are unpaused
+	if query.Paused, err = h.Queries.PausedTargets(query.Name, time.Now()); err != nil {
+		log.Printf("error getting paused nodes %v", err)
+	}
 	leftMetadata := AsideLeftMetadata{
 		EnvUUID:   env.UUID,
 		Carve:     true,
@@ -839,14 +1130,23 @@ func (h *HandlersAdmin) CarvesDetailsHandler(w http.ResponseWriter, r *http.Requ
 	templateData := CarvesDetailsTemplateData{
 		Title:        "Carve details " + query.Name,
 		EnvUUID:      env.UUID,
-		Metadata:     h.TemplateMetadata(ctx, h.ServiceVersion),
+		Metadata:     h.EnvTemplateMetadata(ctx, h.ServiceVersion, env.UUID),
 		LeftMetadata: leftMetadata,
 		Environments: h.allowedEnvironments(ctx[sessions.CtxUser], envAll),
 		Platforms:    platforms,
 		Query:        query,
 		QueryTargets: targets,
+		Window:       queries.WindowOfTargets(targets),
 		Carves:       queryCarves,
 		CarveBlocks:  blocks,
+		ScanPolicy:   env.CarveScanPolicy,
+		Scanner:      h.Settings.CarveScanner() != "",
+		Downloads:    downloads,
+		Manifests:    manifests,
+	}
+	// Administrators of the environment see who downloaded the carved files
+	if h.Users.CheckPermissions(ctx[sessions.CtxUser], users.AdminLevel, env.UUID) {
+		templateData.Accesses = h.entityAccesses(env.ID, []string{environments.AccessCarve, environments.AccessCarveFile}, query.Name)
 	}
 	if err := t.Execute(w, templateData); err != nil {
 		h.Inc(metricAdminErr)
@@ -872,7 +1172,7 @@ func (h *HandlersAdmin) ConfGETHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// Get environment
-	env, err := h.Envs.Get(envVar)
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
 	if err != nil {
 		h.Inc(metricAdminErr)
 		log.Printf("error getting environment: %v", err)
@@ -886,9 +1186,13 @@ func (h *HandlersAdmin) ConfGETHandler(w http.ResponseWriter, r *http.Request) {
 		h.Inc(metricAdminErr)
 		return
 	}
+	// Custom functions to handle formatting
+	funcMap := template.FuncMap{
+		"pastFutureTimes": utils.PastFutureTimes,
+	}
 	// Prepare template
 	tempateFiles := h.NewTemplateFiles(h.TemplatesFolder, "conf.html").filepaths
-	t, err := template.ParseFiles(tempateFiles...)
+	t, err := template.New("conf.html").Funcs(funcMap).ParseFiles(tempateFiles...)
 	if err != nil {
 		h.Inc(metricAdminErr)
 		log.Printf("error getting conf template: %v", err)
@@ -908,13 +1212,133 @@ func (h *HandlersAdmin) ConfGETHandler(w http.ResponseWriter, r *http.Request) {
 		log.Printf("error getting platforms: %v", err)
 		return
 	}
+	// Get active schedule overrides for nodes in this environment
+	overrides, err := h.Envs.EnvScheduleOverrides(env.ID)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting schedule overrides: %v", err)
+		return
+	}
+	// Get performance profiles of this environment
+	profiles, err := h.Envs.ProfilesCtx(r.Context(), env.ID)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting performance profiles: %v", err)
+		return
+	}
+	// Get the active denylist of this environment, with the queries that can be picked and the configuration without them
+	denylist, err := h.Envs.ScheduleDenylistCtx(r.Context(), env.ID)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting denylist: %v", err)
+		return
+	}
+	denyCandidates, err := environments.DenylistCandidates([]byte(env.Configuration))
+	if err != nil {
+		log.Printf("error getting denylist candidates: %v", err)
+	}
+	var denyPreview string
+	if len(denylist) > 0 {
+		// Entries scoped by platform or tag only apply to some nodes, the preview shows the ones for all nodes
+		preview, err := environments.ApplyScheduleDenylist([]byte(env.Configuration), denylist, environments.ProfileNode{}, time.Now())
+		if err != nil {
+			log.Printf("error applying denylist: %v", err)
+		}
+		denyPreview = string(preview)
+	}
+	// Get quick actions of this environment
+	quickActions, err := h.Queries.GetQuickActions(env.ID)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting quick actions: %v", err)
+		return
+	}
+	// Get Windows event channels of this environment, with the flags and queries they generate
+	winChannels, err := h.Envs.WindowsEventsCtx(r.Context(), env.ID)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting windows event channels: %v", err)
+		return
+	}
+	winEvents := environments.PreviewWindowsEvents(winChannels)
+	winSchedule, err := h.Envs.GenSerializedConf(winEvents.Schedule, true)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error serializing windows events schedule: %v", err)
+		return
+	}
+	// Get service keys of this environment, only for its administrators
+	var keys []users.APIKey
+	if h.Users.CheckPermissions(ctx[sessions.CtxUser], users.AdminLevel, env.UUID) {
+		keys, err = h.Users.APIKeys(env.UUID)
+		if err != nil {
+			h.Inc(metricAdminErr)
+			log.Printf("error getting service keys: %v", err)
+			return
+		}
+	}
+	// Get configuration versions of this environment
+	versions, err := h.Envs.ConfigVersions(env.ID)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting configuration versions: %v", err)
+		return
+	}
+	// Get the change scheduled for a maintenance window, if any
+	scheduled, hasScheduled, err := h.Envs.PendingChange(env.ID)
+	if err != nil {
+		log.Printf("error getting scheduled change: %v", err)
+	}
+	// Get what changed since the environment was created or updated from its template
+	var envTemplate environments.EnvTemplate
+	var drift environments.TemplateDiff
+	if env.TemplateID != 0 {
+		envTemplate, drift, err = h.Envs.TemplateDrift(env)
+		if err != nil {
+			log.Printf("error getting template drift: %v", err)
+		}
+	}
+	// Get conflicts between options, schedule and packs, they are displayed even when not checked on save
+	conflicts, err := environments.DetectConflicts(env, h.Settings.QueryBudget())
+	if err != nil {
+		log.Printf("error detecting configuration conflicts: %v", err)
+	}
+	// Get storage used by this environment in the last days
+	now := time.Now()
+	storage, err := h.Envs.StorageReport(env, now.AddDate(0, 0, -environments.DefaultStorageDays), now)
+	if err != nil {
+		log.Printf("error getting storage: %v", err)
+	}
 	// Prepare template data
 	templateData := ConfTemplateData{
 		Title:        env.Name + " Configuration",
-		Metadata:     h.TemplateMetadata(ctx, h.ServiceVersion),
+		Metadata:     h.EnvTemplateMetadata(ctx, h.ServiceVersion, env.UUID),
 		Environment:  env,
 		Environments: h.allowedEnvironments(ctx[sessions.CtxUser], envAll),
 		Platforms:    platforms,
+		Overrides:    overrides,
+		Profiles:     profiles,
+		Denylist:     denylist,
+		DenyCands:    denyCandidates,
+		DenyPreview:  denyPreview,
+		QuickActions: quickActions,
+		QuickTypes:   queries.QuickActionTypes,
+		Approved:     h.approvedTemplates(),
+		WinEvents:    winEvents,
+		WinSchedule:  winSchedule,
+		WinExport:    environments.SerializeWindowsEvents(winChannels),
+		APIKeys:      keys,
+		APIKeyScopes: users.APIKeyScopes,
+		Versions:     versions,
+		Scheduled:    scheduled,
+		HasScheduled: hasScheduled,
+		Template:     envTemplate,
+		Drift:        drift,
+		Conflicts:    conflicts,
+		Storage:      storage,
+		StorageDaily: environments.DailyStorage(storage.History),
+		Categories:   environments.StorageCategories,
+		QuotaActions: environments.QuotaActions,
 	}
 	if err := t.Execute(w, templateData); err != nil {
 		h.Inc(metricAdminErr)
@@ -940,7 +1364,7 @@ func (h *HandlersAdmin) EnrollGETHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	// Get environment
-	env, err := h.Envs.Get(envVar)
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
 	if err != nil {
 		h.Inc(metricAdminErr)
 		log.Printf("error getting environment: %v", err)
@@ -976,6 +1400,31 @@ func (h *HandlersAdmin) EnrollGETHandler(w http.ResponseWriter, r *http.Request)
 		log.Printf("error getting platforms: %v", err)
 		return
 	}
+	// Get propagation of the certificate
+	stats, err := h.Nodes.GetStatsByEnv(env.Name, h.Settings.InactiveHours())
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting stats %v", err)
+		return
+	}
+	certStatus, err := h.Envs.GetCertificateStatus(env, stats.Total)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting certificate status %v", err)
+		return
+	}
+	enrollFields, err := h.Envs.EnrollFieldsCtx(r.Context(), env.ID)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting enroll fields %v", err)
+		return
+	}
+	versions, err := h.Nodes.GetVersionReport(env.ID, func(platform string) string {
+		return environments.PinnedVersion(env, platform)
+	})
+	if err != nil {
+		log.Printf("error getting osquery versions %v", err)
+	}
 	// Prepare template data
 	shellQuickAdd, _ := environments.QuickAddOneLinerShell((env.Certificate != ""), env)
 	powershellQuickAdd, _ := environments.QuickAddOneLinerPowershell((env.Certificate != ""), env)
@@ -983,7 +1432,7 @@ func (h *HandlersAdmin) EnrollGETHandler(w http.ResponseWriter, r *http.Request)
 	powershellQuickRemove, _ := environments.QuickRemoveOneLinerPowershell((env.Certificate != ""), env)
 	templateData := EnrollTemplateData{
 		Title:                 env.Name + " Enroll",
-		Metadata:              h.TemplateMetadata(ctx, h.ServiceVersion),
+		Metadata:              h.EnvTemplateMetadata(ctx, h.ServiceVersion, env.UUID),
 		EnvName:               env.Name,
 		EnvUUID:               env.UUID,
 		OnelinerExpiration:    h.Settings.OnelinerExpiration(),
@@ -998,6 +1447,14 @@ func (h *HandlersAdmin) EnrollGETHandler(w http.ResponseWriter, r *http.Request)
 		Secret:                env.Secret,
 		Flags:                 env.Flags,
 		Certificate:           env.Certificate,
+		CertificateStatus:     certStatus,
+		EnrollFields:          enrollFields,
+		Locale:                locales.Names()[locales.Normalize(env.Locale)],
+		OsqueryPin:            env.OsqueryPin,
+		OsqueryPins:           env.OsqueryPins,
+		OsqueryPackages:       environments.OsqueryPackages(env),
+		OsqueryVersions:       versions,
+		FlagWarnings:          environments.LintFlagsEnv(env),
 		Environments:          h.allowedEnvironments(ctx[sessions.CtxUser], envAll),
 		Platforms:             platforms,
 	}
@@ -1060,7 +1517,7 @@ func (h *HandlersAdmin) NodeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// Get environment
-	env, err := h.Envs.Get(node.Environment)
+	env, err := h.Envs.GetCtx(r.Context(), node.Environment)
 	if err != nil {
 		h.Inc(metricAdminErr)
 		log.Printf("error getting environment: %v", err)
@@ -1107,6 +1564,99 @@ func (h *HandlersAdmin) NodeHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
+	// Get network history for this node
+	networkHistory, err := h.Nodes.GetHistoryIPAddresses(node.UUID)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting network history: %v", err)
+		return
+	}
+	// Get the history of environments and tags of this node, newest first
+	membership, err := h.Nodes.GetMembershipChanges(node.UUID, time.Time{}, time.Now())
+	if err != nil {
+		log.Printf("error getting membership history: %v", err)
+	}
+	for i, j := 0, len(membership)-1; i < j; i, j = i+1, j-1 {
+		membership[i], membership[j] = membership[j], membership[i]
+	}
+	// Get active schedule overrides and the queries that can be overridden for this node
+	overrides, err := h.Envs.NodeScheduleOverrides(node.UUID)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting schedule overrides: %v", err)
+		return
+	}
+	scheduleNames, err := h.Envs.NodeScheduleNames(env, node.Platform)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting schedule names: %v", err)
+		return
+	}
+	var names []string
+	for n := range scheduleNames {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	// Get the decommission of this node, with the remove one-liner for nodes without osctrld
+	var decommission nodes.NodeDecommission
+	var removeOneLiner string
+	if d, err := h.Nodes.GetDecommission(node.UUID); err == nil && d.State != nodes.DecommissionSilent {
+		decommission = d
+		if node.Platform == settings.PlatformWindows {
+			removeOneLiner, _ = environments.QuickRemoveOneLinerPowershell((env.Certificate != ""), env)
+		} else {
+			removeOneLiner, _ = environments.QuickRemoveOneLinerShell((env.Certificate != ""), env)
+		}
+	}
+	// Get the pause of this node, only if it is still paused
+	var pause nodes.NodePause
+	if p, err := h.Nodes.GetPause(node.UUID); err == nil && p.Active && !p.Expired(time.Now()) {
+		pause = p
+	}
+	// Get the identity of this node, in environments that bind them
+	identity := NodeIdentityEntry{Policy: env.IdentityPolicy}
+	if i, err := h.Nodes.GetIdentity(node.UUID); err == nil {
+		identity.Bound = true
+		identity.Identity = i
+		identity.Original = i.OriginalFingerprint()
+		identity.Current = i.CurrentFingerprint()
+		identity.Changes = i.Diff()
+	}
+	// Get the enroll metadata of this node, fields defined later show as unset
+	enrollFields, err := h.Envs.EnrollFieldsCtx(r.Context(), env.ID)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting enroll fields: %v", err)
+		return
+	}
+	enrollMetadata := enrollMetadataEntries(enrollFields, nodes.EnrollMetadataValues(node))
+	// Get the custom attributes of this node
+	attributes, err := h.Nodes.GetAttributes(node.ID)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting attributes: %v", err)
+		return
+	}
+	// Get the performance profile of this node, nodes without profile use the environment intervals
+	profiles, err := h.Envs.ProfilesCtx(r.Context(), env.ID)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting performance profiles: %v", err)
+		return
+	}
+	profile := nodeProfileEntry(env, profiles, node, nodeTags)
+	// Status tokens for help desk can only be generated when enabled
+	var statusTokenHours int64
+	if h.Settings.StatusTokens() {
+		statusTokenHours = h.Settings.StatusTokenMaxHours()
+	}
+	// Get the mutes that apply to this node, its own and the ones of the environment
+	mutes, err := h.Responses.Mutes(env.ID, time.Now())
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting mutes: %v", err)
+		return
+	}
 	leftMetadata := AsideLeftMetadata{
 		EnvUUID:      env.UUID,
 		ActiveNode:   nodes.IsActive(node, h.Settings.InactiveHours()),
@@ -1115,18 +1665,36 @@ func (h *HandlersAdmin) NodeHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	// Prepare template data
 	templateData := NodeTemplateData{
-		Title:        "Node View " + node.Hostname,
-		EnvUUID:      env.UUID,
-		Metadata:     h.TemplateMetadata(ctx, h.ServiceVersion),
-		LeftMetadata: leftMetadata,
-		Node:         node,
-		NodeTags:     nodeTags,
-		TagsForNode:  tags,
-		Environments: h.allowedEnvironments(ctx[sessions.CtxUser], envAll),
-		Platforms:    platforms,
-		Dashboard:    dashboardEnabled,
-		Packs:        packs,
-		Schedule:     schedule,
+		Title:          "Node View " + node.Hostname,
+		EnvUUID:        env.UUID,
+		Metadata:       h.EnvTemplateMetadata(ctx, h.ServiceVersion, env.UUID),
+		LeftMetadata:   leftMetadata,
+		Node:           node,
+		NodeTags:       nodeTags,
+		TagsForNode:    tags,
+		Environments:   h.allowedEnvironments(ctx[sessions.CtxUser], envAll),
+		Platforms:      platforms,
+		Dashboard:      dashboardEnabled,
+		Packs:          packs,
+		Schedule:       schedule,
+		NetworkHistory: networkHistory,
+		Membership:     membership,
+		Overrides:      overrides,
+		ScheduleNames:  names,
+		Decommission:   decommission,
+		Pause:          pause,
+		RemoveOneLiner: removeOneLiner,
+		Identity:       identity,
+		EnrollMetadata: enrollMetadata,
+		Attributes:     attributes,
+		Profile:        profile,
+		StatusTokens:   statusTokenHours,
+		ClockSkew:      nodeSkew(node, h.Settings.ClockSkewSeconds()).Display,
+		ClockSkewed:    nodes.ClockSkewed(node, h.Settings.ClockSkewSeconds()),
+		QuickActions:   h.quickActionsFor(ctx[sessions.CtxUser], env),
+		Mutes:          responses.NodeMutes(mutes, node.UUID),
+		MuteKinds:      responses.MuteKinds,
+		Now:            time.Now(),
 	}
 	if err := t.Execute(w, templateData); err != nil {
 		h.Inc(metricAdminErr)
@@ -1173,9 +1741,39 @@ func (h *HandlersAdmin) EnvsGETHandler(w http.ResponseWriter, r *http.Request) {
 		log.Printf("error getting platforms: %v", err)
 		return
 	}
+	// Get storm protection state for all environments
+	storms, err := h.Envs.StormStates()
+	if err != nil {
+		log.Printf("error getting storm states %v", err)
+	}
+	// Get latest storage and quotas for all environments
+	storage, err := h.Envs.StorageTotals()
+	if err != nil {
+		log.Printf("error getting storage totals %v", err)
+	}
+	quotas, err := h.Envs.StorageQuotas()
+	if err != nil {
+		log.Printf("error getting storage quotas %v", err)
+	}
+	// Get templates to create environments
+	envTemplates, err := h.Envs.Templates()
+	if err != nil {
+		log.Printf("error getting environment templates %v", err)
+	}
+	policies, err := h.Envs.CategoryPolicies()
+	if err != nil {
+		log.Printf("error getting category policies %v", err)
+	}
 	// Prepare template data
 	templateData := EnvironmentsTemplateData{
 		Title:        "Manage environments",
+		Storms:       storms,
+		Storage:      storage,
+		Quotas:       quotas,
+		Locales:      locales.Names(),
+		Templates:    envTemplates,
+		Categories:   environments.Categories,
+		Policies:     policies,
 		Metadata:     h.TemplateMetadata(ctx, h.ServiceVersion),
 		Environments: h.allowedEnvironments(ctx[sessions.CtxUser], envAll),
 		Platforms:    platforms,
@@ -1261,6 +1859,28 @@ func (h *HandlersAdmin) SettingsGETHandler(w http.ResponseWriter, r *http.Reques
 		CurrentSettings: _settings,
 		ServiceConfig:   toJSONConfigurationService(svcJSON),
 	}
+	// Active filters for the always logger and how much traffic they match
+	if serviceVar == settings.ServiceTLS {
+		for _, s := range _settings {
+			switch s.Name {
+			case settings.AlwaysLogTypes:
+				templateData.AlwaysTypes = s.String
+			case settings.AlwaysLogNames:
+				templateData.AlwaysNames = s.String
+			case settings.AlwaysLogDisabled:
+				templateData.AlwaysDisabled = s.String
+			}
+		}
+		filters := logging.AlwaysFilters{
+			Types:        logging.SplitFilter(templateData.AlwaysTypes),
+			Names:        logging.SplitFilter(templateData.AlwaysNames),
+			DisabledEnvs: logging.SplitFilter(templateData.AlwaysDisabled),
+		}
+		templateData.AlwaysEstimate, err = h.alwaysEstimate(filters)
+		if err != nil {
+			log.Printf("error estimating always log traffic: %v", err)
+		}
+	}
 	if err := t.Execute(w, templateData); err != nil {
 		h.Inc(metricAdminErr)
 		log.Printf("template error %v", err)
@@ -1318,6 +1938,20 @@ func (h *HandlersAdmin) UsersGETHandler(w http.ResponseWriter, r *http.Request)
 		log.Printf("error getting users: %v", err)
 		return
 	}
+	// Get lockouts after failed logins
+	lockouts, err := h.Users.ActiveLockouts()
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting lockouts: %v", err)
+		return
+	}
+	// Get teams with their members
+	teams, err := h.Users.Teams()
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting teams: %v", err)
+		return
+	}
 	// Prepare template data
 	templateData := UsersTemplateData{
 		Title:        "Manage users",
@@ -1325,6 +1959,8 @@ func (h *HandlersAdmin) UsersGETHandler(w http.ResponseWriter, r *http.Request)
 		Environments: h.allowedEnvironments(ctx[sessions.CtxUser], envAll),
 		Platforms:    platforms,
 		CurrentUsers: users,
+		Lockouts:     lockouts,
+		Teams:        teams,
 	}
 	if err := t.Execute(w, templateData); err != nil {
 		h.Inc(metricAdminErr)
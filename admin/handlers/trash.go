@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/admin/sessions"
+	"github.com/jmpsec/osctrl/backend"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+// Helper to get the items in the trash of an environment, with the saved queries of a user, newest first
+func (h *HandlersAdmin) trashItems(envID uint, user string) ([]backend.TrashItem, error) {
+	days := h.Settings.TrashRetentionDays()
+	items, err := h.Queries.TrashItems(envID, user, days, time.Now())
+	if err != nil {
+		return items, err
+	}
+	tagItems, err := h.Tags.TrashItems(days, time.Now())
+	if err != nil {
+		return items, err
+	}
+	items = append(items, tagItems...)
+	sort.SliceStable(items, func(i, j int) bool { return items[i].DeletedAt.After(items[j].DeletedAt) })
+	return items, nil
+}
+
+// TrashGETHandler for GET requests for /trash/{environment} to show the deleted items that can be restored
+func (h *HandlersAdmin) TrashGETHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), false)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["environment"]
+	if !ok {
+		h.Inc(metricAdminErr)
+		log.Println("error getting environment")
+		return
+	}
+	// Get environment
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environment: %v", err)
+		return
+	}
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Check permissions
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.QueryLevel, env.UUID) {
+		log.Printf("%s has insuficient permissions", ctx[sessions.CtxUser])
+		h.Inc(metricAdminErr)
+		return
+	}
+	items, err := h.trashItems(env.ID, ctx[sessions.CtxUser])
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting trash: %v", err)
+		return
+	}
+	// Prepare template
+	tempateFiles := h.NewTemplateFiles(h.TemplatesFolder, "trash.html").filepaths
+	t, err := template.ParseFiles(tempateFiles...)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting trash template: %v", err)
+		return
+	}
+	// Get stats for all environments
+	envAll, err := h.Envs.AllCtx(r.Context())
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environments %v", err)
+		return
+	}
+	// Get stats for all platforms
+	platforms, err := h.Nodes.GetAllPlatforms()
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting platforms: %v", err)
+		return
+	}
+	// Prepare template data
+	templateData := TrashTemplateData{
+		Title:        env.Name + " Trash",
+		EnvName:      env.Name,
+		EnvUUID:      env.UUID,
+		Items:        items,
+		Days:         h.Settings.TrashRetentionDays(),
+		Metadata:     h.EnvTemplateMetadata(ctx, h.ServiceVersion, env.UUID),
+		LeftMetadata: AsideLeftMetadata{EnvUUID: env.UUID},
+		Environments: h.allowedEnvironments(ctx[sessions.CtxUser], envAll),
+		Platforms:    platforms,
+	}
+	if err := t.Execute(w, templateData); err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("template error %v", err)
+		return
+	}
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Trash template served")
+	}
+	h.Inc(metricAdminOK)
+}
+
+// TrashPOSTHandler for POST requests to restore items from the trash or delete them permanently
+func (h *HandlersAdmin) TrashPOSTHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), true)
+	var t TrashRequest
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	user := ctx[sessions.CtxUser]
+	// Parse request JSON body
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Decoding POST body")
+	}
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		adminErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Check CSRF Token
+	if !sessions.CheckCSRFToken(ctx[sessions.CtxCSRF], t.CSRFToken) {
+		adminErrorResponse(w, "invalid CSRF token", http.StatusInternalServerError, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	if !backend.ValidTrashKind(t.Kind) {
+		adminErrorResponse(w, fmt.Sprintf("invalid kind %s", t.Kind), http.StatusBadRequest, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Templates and tags are not scoped to an environment, they are only managed by admins
+	var env environments.TLSEnvironment
+	var err error
+	if t.Kind == backend.TrashTemplates || t.Kind == backend.TrashTags {
+		if !h.Users.CheckPermissions(user, users.AdminLevel, users.NoEnvironment) {
+			adminErrorResponse(w, fmt.Sprintf("%s has insuficient permissions", user), http.StatusForbidden, nil)
+			h.Inc(metricAdminErr)
+			return
+		}
+	} else {
+		env, err = h.Envs.GetCtx(r.Context(), t.Environment)
+		if err != nil {
+			adminErrorResponse(w, "error getting environment", http.StatusBadRequest, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		if !h.Users.CheckPermissions(user, users.QueryLevel, env.UUID) {
+			adminErrorResponse(w, fmt.Sprintf("%s has insuficient permissions", user), http.StatusForbidden, nil)
+			h.Inc(metricAdminErr)
+			return
+		}
+	}
+	var msg string
+	switch t.Action {
+	case "restore":
+		switch t.Kind {
+		case backend.TrashQueries, backend.TrashCarves:
+			err = h.Queries.Restore(t.Name, env.ID)
+		case backend.TrashSaved:
+			err = h.Queries.RestoreSaved(t.Name, user, env.ID)
+		case backend.TrashTemplates:
+			err = h.Queries.RestoreTemplate(t.Name)
+		case backend.TrashTags:
+			err = h.Tags.Restore(t.Name, user)
+		}
+		msg = fmt.Sprintf("%s restored successfully", t.Name)
+	case "delete":
+		// Deletes that skip the trash can not be undone, only admins can do them
+		if !h.Users.CheckPermissions(user, users.AdminLevel, users.NoEnvironment) {
+			adminErrorResponse(w, "only admins can delete permanently", http.StatusForbidden, nil)
+			h.Inc(metricAdminErr)
+			return
+		}
+		switch t.Kind {
+		case backend.TrashQueries, backend.TrashCarves:
+			err = h.Queries.HardDelete(t.Name, env.ID, t.Kind, user)
+		case backend.TrashSaved:
+			err = h.Queries.HardDeleteSaved(t.Name, user, env.ID)
+		case backend.TrashTemplates:
+			err = h.Queries.HardDeleteTemplate(t.Name, user)
+		case backend.TrashTags:
+			err = h.Tags.HardDelete(t.Name, user)
+		}
+		msg = fmt.Sprintf("%s deleted permanently", t.Name)
+	default:
+		adminErrorResponse(w, "invalid action", http.StatusBadRequest, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	if err != nil {
+		adminErrorResponse(w, fmt.Sprintf("error with %s of %s", t.Action, t.Name), http.StatusBadRequest, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	if t.Action == "delete" {
+		log.Printf("%s %s deleted permanently by %s", t.Kind, t.Name, user)
+		switch t.Kind {
+		case backend.TrashQueries:
+			h.recordActivity(env, environments.ActivityQueries, user, msg, t.Name, "")
+		case backend.TrashCarves:
+			h.recordActivity(env, environments.ActivityCarves, user, msg, t.Name, "")
+		}
+	}
+	adminOKResponse(w, msg)
+	// Serialize and send response
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Trash response sent")
+	}
+	h.Inc(metricAdminOK)
+}
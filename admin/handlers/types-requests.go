@@ -1,5 +1,12 @@
 package handlers
 
+import (
+	"time"
+
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/nodes"
+)
+
 // LoginRequest to receive login credentials
 type LoginRequest struct {
 	Username string `json:"username"`
@@ -13,24 +20,152 @@ type LogoutRequest struct {
 
 // DistributedQueryRequest to receive query requests
 type DistributedQueryRequest struct {
-	CSRFToken    string   `json:"csrftoken"`
-	Environments []string `json:"environment_list"`
-	Platforms    []string `json:"platform_list"`
-	UUIDs        []string `json:"uuid_list"`
-	Hosts        []string `json:"host_list"`
-	Save         bool     `json:"save"`
-	Name         string   `json:"name"`
-	Query        string   `json:"query"`
+	CSRFToken      string            `json:"csrftoken"`
+	Environments   []string          `json:"environment_list"`
+	Platforms      []string          `json:"platform_list"`
+	UUIDs          []string          `json:"uuid_list"`
+	Hosts          []string          `json:"host_list"`
+	Attributes     []string          `json:"attribute_list"`
+	Save           bool              `json:"save"`
+	Name           string            `json:"name"`
+	Query          string            `json:"query"`
+	Profile        bool              `json:"profile"`
+	Sample         int               `json:"sample"`
+	Template       string            `json:"template"`
+	Variables      map[string]string `json:"variables"`
+	Description    string            `json:"description"`
+	TicketID       string            `json:"ticket_id"`
+	TicketURL      string            `json:"ticket_url"`
+	Labels         []string          `json:"labels"`
+	EnrolledAfter  string            `json:"enrolled_after"`
+	EnrolledBefore string            `json:"enrolled_before"`
+	SeenAfter      string            `json:"seen_after"`
+	SeenBefore     string            `json:"seen_before"`
+	ConfirmNotices bool              `json:"confirm_notices"`
+	ConfirmName    string            `json:"confirm_name"`
+	Group          string            `json:"group"`
+	Priority       int               `json:"priority"`
+	Override       bool              `json:"override"`
+	Justification  string            `json:"justification"`
+	Expiration     string            `json:"expiration"`
+	Visibility     string            `json:"visibility"`
+	VisibilityTeam string            `json:"visibility_team"`
+}
+
+// QueryGroupRequest to receive the concurrency limits of a group of queries, zero for no limit
+type QueryGroupRequest struct {
+	CSRFToken  string `json:"csrftoken"`
+	Name       string `json:"name"`
+	MaxPerNode int    `json:"max_per_node"`
+	MaxTotal   int    `json:"max_total"`
+}
+
+// QueryPolicyRequest to receive query policy requests, tables and columns as comma separated patterns
+type QueryPolicyRequest struct {
+	CSRFToken string `json:"csrftoken"`
+	Action    string `json:"action"`
+	Subject   string `json:"subject"`
+	Mode      string `json:"mode"`
+	Tables    string `json:"tables"`
+	Columns   string `json:"columns"`
+}
+
+// QuickActionRequest to receive changes to quick actions, variables as name=value separated by commas
+type QuickActionRequest struct {
+	CSRFToken string `json:"csrftoken"`
+	Action    string `json:"action"`
+	Name      string `json:"name"`
+	Label     string `json:"label"`
+	Icon      string `json:"icon"`
+	Type      string `json:"type"`
+	Template  string `json:"template"`
+	Variables string `json:"variables"`
+	Path      string `json:"path"`
+	Tag       string `json:"tag"`
+}
+
+// QuickActionRunRequest to receive requests to preview or run a quick action, with the version that was confirmed
+type QuickActionRunRequest struct {
+	CSRFToken      string   `json:"csrftoken"`
+	Action         string   `json:"action"`
+	Name           string   `json:"name"`
+	Version        int      `json:"version"`
+	UUIDs          []string `json:"uuids"`
+	ConfirmNotices bool     `json:"confirm_notices"`
+}
+
+// QueryContextRequest to receive changes to the investigation context of a query, labels as key=value
+type QueryContextRequest struct {
+	CSRFToken   string   `json:"csrftoken"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	TicketID    string   `json:"ticket_id"`
+	TicketURL   string   `json:"ticket_url"`
+	Labels      []string `json:"labels"`
+}
+
+// QueryVisibilityRequest to receive who can see the results of a query
+type QueryVisibilityRequest struct {
+	CSRFToken  string `json:"csrftoken"`
+	Name       string `json:"name"`
+	Visibility string `json:"visibility"`
+	Team       string `json:"team"`
+}
+
+// QueryBreakGlassRequest to receive an admin breaking the glass to see the results of a restricted query
+type QueryBreakGlassRequest struct {
+	CSRFToken string `json:"csrftoken"`
+	Name      string `json:"name"`
+	Reason    string `json:"reason"`
+}
+
+// UserTeamRequest to receive the changes of the members of teams
+type UserTeamRequest struct {
+	CSRFToken string `json:"csrftoken"`
+	Action    string `json:"action"`
+	Team      string `json:"team"`
+	Username  string `json:"username"`
+}
+
+// QueryShareRequest to receive requests of sharing exports of queries, deny columns are comma separated
+type QueryShareRequest struct {
+	CSRFToken   string   `json:"csrftoken"`
+	Queries     []string `json:"queries"`
+	Format      string   `json:"format"`
+	DropIPs     bool     `json:"drop_ips"`
+	DenyColumns string   `json:"deny_columns"`
+}
+
+// ExportCancelRequest to receive requests to cancel export jobs of large results
+type ExportCancelRequest struct {
+	CSRFToken string `json:"csrftoken"`
+	JobID     string `json:"job"`
+}
+
+// QueryRehydrateRequest to receive requests to rehydrate the results of archived queries
+type QueryRehydrateRequest struct {
+	CSRFToken string `json:"csrftoken"`
+	Name      string `json:"name"`
 }
 
 // DistributedCarveRequest to receive carve requests
 type DistributedCarveRequest struct {
-	CSRFToken    string   `json:"csrftoken"`
-	Environments []string `json:"environment_list"`
-	Platforms    []string `json:"platform_list"`
-	UUIDs        []string `json:"uuid_list"`
-	Hosts        []string `json:"host_list"`
-	Path         string   `json:"path"`
+	CSRFToken      string   `json:"csrftoken"`
+	Environments   []string `json:"environment_list"`
+	Platforms      []string `json:"platform_list"`
+	UUIDs          []string `json:"uuid_list"`
+	Hosts          []string `json:"host_list"`
+	Attributes     []string `json:"attribute_list"`
+	Path           string   `json:"path"`
+	EnrolledAfter  string   `json:"enrolled_after"`
+	EnrolledBefore string   `json:"enrolled_before"`
+	SeenAfter      string   `json:"seen_after"`
+	SeenBefore     string   `json:"seen_before"`
+	ConfirmNotices bool     `json:"confirm_notices"`
+	ConfirmName    string   `json:"confirm_name"`
+	Justification  string   `json:"justification"`
+	Visibility     string   `json:"visibility"`
+	VisibilityTeam string   `json:"visibility_team"`
 }
 
 // DistributedQueryActionRequest to receive query requests
@@ -52,6 +187,8 @@ type NodeMultiActionRequest struct {
 	CSRFToken string   `json:"csrftoken"`
 	Action    string   `json:"action"`
 	UUIDs     []string `json:"uuids"`
+	Reason    string   `json:"reason"`
+	Expires   string   `json:"expires"`
 }
 
 // SettingsRequest to receive changes to settings
@@ -62,23 +199,211 @@ type SettingsRequest struct {
 	Type      string `json:"type"`
 	Name      string `json:"name"`
 	Value     string `json:"value"`
+	// Filters for the always logger, as comma separated values
+	Types        string `json:"types"`
+	Names        string `json:"names"`
+	DisabledEnvs string `json:"disabled_envs"`
+}
+
+// NodeIdentityRequest to receive resolutions of identity mismatches of a node
+type NodeIdentityRequest struct {
+	CSRFToken string `json:"csrftoken"`
+	Action    string `json:"action"`
+	UUID      string `json:"uuid"`
+}
+
+// NodeMergeRequest to receive reviews of duplicate nodes and manual merges
+type NodeMergeRequest struct {
+	CSRFToken string `json:"csrftoken"`
+	Action    string `json:"action"`
+	ID        uint   `json:"id"`
+	Into      string `json:"into"`
+	From      string `json:"from"`
+}
+
+// ScheduleOverrideRequest to receive changes to the schedule overrides of a node
+type ScheduleOverrideRequest struct {
+	CSRFToken string `json:"csrftoken"`
+	Action    string `json:"action"`
+	UUID      string `json:"uuid"`
+	ID        uint   `json:"id"`
+	Query     string `json:"query"`
+	Interval  int    `json:"interval"`
+	Hours     int    `json:"hours"`
+}
+
+// StatusTokenRequest to receive requests to generate node status tokens
+type StatusTokenRequest struct {
+	CSRFToken string `json:"csrftoken"`
+	UUID      string `json:"uuid"`
+	Hours     int64  `json:"hours"`
+}
+
+// StatusTokenResponse to be returned to node status token requests
+type StatusTokenResponse struct {
+	Token        string `json:"token"`
+	Path         string `json:"path"`
+	Expiration   string `json:"expiration"`
+	ExpirationTS string `json:"exp_ts"`
 }
 
 // ConfigurationRequest to receive changes to configuration
 type ConfigurationRequest struct {
-	CSRFToken        string `json:"csrftoken"`
-	ConfigurationB64 string `json:"configuration"`
-	OptionsB64       string `json:"options"`
-	ScheduleB64      string `json:"schedule"`
-	PacksB64         string `json:"packs"`
-	DecoratorsB64    string `json:"decorators"`
-	ATCB64           string `json:"atc"`
+	CSRFToken        string    `json:"csrftoken"`
+	ConfigurationB64 string    `json:"configuration"`
+	OptionsB64       string    `json:"options"`
+	ScheduleB64      string    `json:"schedule"`
+	PacksB64         string    `json:"packs"`
+	DecoratorsB64    string    `json:"decorators"`
+	ATCB64           string    `json:"atc"`
+	ApplyAt          time.Time `json:"apply_at"`
+	Replace          bool      `json:"replace"`
+	Note             string    `json:"note"`
+}
+
+// ProfileLaunchRequest to launch the real query from a profile query
+type ProfileLaunchRequest struct {
+	CSRFToken string `json:"csrftoken"`
 }
 
 // EnrollRequest to receive changes to enroll certificates
 type EnrollRequest struct {
+	CSRFToken      string    `json:"csrftoken"`
+	CertificateB64 string    `json:"certificate"`
+	ActivateAt     time.Time `json:"activate_at"`
+}
+
+// StorageQuotaRequest to receive changes to the storage quota of an environment
+type StorageQuotaRequest struct {
+	CSRFToken string   `json:"csrftoken"`
+	MaxSize   string   `json:"max_size"`
+	Actions   []string `json:"actions"`
+}
+
+// CarveLimitsRequest to receive changes to the carve limits of an environment, empty or zero values are unlimited
+type CarveLimitsRequest struct {
+	CSRFToken       string `json:"csrftoken"`
+	MaxSize         string `json:"max_size"`
+	MaxNodeSessions int    `json:"max_node_sessions"`
+	MaxEnvSessions  int    `json:"max_env_sessions"`
+	DailySize       string `json:"daily_size"`
+	DeniedPaths     string `json:"denied_paths"`
+}
+
+// ProfilesRequest to receive changes to performance profiles
+type ProfilesRequest struct {
 	CSRFToken      string `json:"csrftoken"`
-	CertificateB64 string `json:"certificate"`
+	Action         string `json:"action"`
+	Name           string `json:"name"`
+	Priority       int    `json:"priority"`
+	Tag            string `json:"tag"`
+	Platform       string `json:"platform"`
+	HardwareModel  string `json:"hardware_model"`
+	ConfigInterval int    `json:"config_interval"`
+	LogInterval    int    `json:"log_interval"`
+	QueryInterval  int    `json:"query_interval"`
+}
+
+// DenylistRequest to receive changes to the scheduled query denylist of an environment
+type DenylistRequest struct {
+	CSRFToken string `json:"csrftoken"`
+	Action    string `json:"action"`
+	Pack      string `json:"pack"`
+	Query     string `json:"query"`
+	Platform  string `json:"platform"`
+	Tag       string `json:"tag"`
+	Reason    string `json:"reason"`
+	Hours     int    `json:"hours"`
+	ID        uint   `json:"id"`
+}
+
+// ResponsesRequest to receive changes to the response actions configuration and triggers of an environment
+type ResponsesRequest struct {
+	CSRFToken  string `json:"csrftoken"`
+	Action     string `json:"action"`
+	Enabled    bool   `json:"enabled"`
+	Allowed    string `json:"allowed"`
+	Cooldown   int    `json:"cooldown"`
+	MaxPerHour int    `json:"max_per_hour"`
+	Name       string `json:"name"`
+	Query      string `json:"query"`
+	Column     string `json:"column"`
+	Value      string `json:"value"`
+	Kind       string `json:"kind"`
+	Template   string `json:"template"`
+	Variables  string `json:"variables"`
+	Path       string `json:"path"`
+	MuteKind   string `json:"mute_kind"`
+	Rule       string `json:"rule"`
+	UUID       string `json:"uuid"`
+	Reason     string `json:"reason"`
+	Hours      int    `json:"hours"`
+	Permanent  bool   `json:"permanent"`
+	Notify     bool   `json:"notify"`
+	MuteID     uint   `json:"mute_id"`
+}
+
+// WebhooksRequest to receive changes to the webhook endpoints of an environment
+type WebhooksRequest struct {
+	CSRFToken  string   `json:"csrftoken"`
+	Action     string   `json:"action"`
+	Name       string   `json:"name"`
+	URL        string   `json:"url"`
+	Events     []string `json:"events"`
+	DeliveryID uint     `json:"delivery_id"`
+}
+
+// PackSyncRequest to receive changes to the pack source of an environment and resolutions of its conflicts
+type PackSyncRequest struct {
+	CSRFToken  string `json:"csrftoken"`
+	Action     string `json:"action"`
+	URL        string `json:"url"`
+	Branch     string `json:"branch"`
+	PathGlob   string `json:"path_glob"`
+	AuthKind   string `json:"auth_kind"`
+	Credential string `json:"credential"`
+	Interval   int    `json:"interval"`
+	Enabled    bool   `json:"enabled"`
+	Pack       string `json:"pack"`
+	Resolution string `json:"resolution"`
+}
+
+// WebhookSecretResponse to be returned when the secret of a webhook endpoint is generated, it is only shown then
+type WebhookSecretResponse struct {
+	Message string `json:"message"`
+	Secret  string `json:"secret"`
+}
+
+// APIKeysRequest to receive changes to service keys of an environment
+type APIKeysRequest struct {
+	CSRFToken string   `json:"csrftoken"`
+	Action    string   `json:"action"`
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	Days      int      `json:"days"`
+	Overlap   int      `json:"overlap"`
+	KeyID     string   `json:"key_id"`
+}
+
+// WindowsEventsRequest to receive changes to Windows event channels
+type WindowsEventsRequest struct {
+	CSRFToken string `json:"csrftoken"`
+	Action    string `json:"action"`
+	Channel   string `json:"channel"`
+	Enabled   bool   `json:"enabled"`
+	Interval  int    `json:"interval"`
+	MaxRows   int    `json:"max_rows"`
+	Import    string `json:"import"`
+}
+
+// EnrollFieldsRequest to receive changes to enroll metadata fields
+type EnrollFieldsRequest struct {
+	CSRFToken   string `json:"csrftoken"`
+	Action      string `json:"action"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Regex       string `json:"regex"`
+	Required    bool   `json:"required"`
 }
 
 // IntervalsRequest to receive changes to intervals
@@ -98,14 +423,72 @@ type ExpirationRequest struct {
 
 // EnvironmentsRequest to receive changes to environments
 type EnvironmentsRequest struct {
+	CSRFToken       string `json:"csrftoken"`
+	Action          string `json:"action"`
+	UUID            string `json:"uuid"`
+	Name            string `json:"name"`
+	Hostname        string `json:"hostname"`
+	Type            string `json:"type"`
+	Icon            string `json:"icon"`
+	DebugHTTP       bool   `json:"debughttp"`
+	CarveScan       string `json:"carvescan"`
+	Identity        string `json:"identity"`
+	Storm           int    `json:"storm"`
+	QueryExpiration int    `json:"query_expiration"`
+	EnrollLoopLimit int    `json:"enroll_loop_limit"`
+	EnrollLoopQuiet int    `json:"enroll_loop_quiet"`
+	EnrollBackoff   bool   `json:"enroll_backoff"`
+	NamingLower     bool   `json:"naming_lowercase"`
+	NamingDomains   string `json:"naming_domains"`
+	NamingFQDN      bool   `json:"naming_prefer_fqdn"`
+	DryRun          bool   `json:"dry_run"`
+	PseudonymCols   string `json:"pseudonym_columns"`
+	PseudonymTables string `json:"pseudonym_tables"`
+	Locale          string `json:"locale"`
+	Platforms       string `json:"platforms"`
+	OsqueryPin      string `json:"osquery_pin"`
+	OsqueryPins     string `json:"osquery_pins"`
+	Template        string `json:"template"`
+	Category        string `json:"category"`
+	ConfirmTargets  int    `json:"confirm_targets"`
+	RequireNote     bool   `json:"require_note"`
+}
+
+// NamingResponse to return the display names of nodes changed by a naming policy, or that would change
+type NamingResponse struct {
+	Message string             `json:"message"`
+	Report  nodes.NamingReport `json:"report"`
+}
+
+// EnvTemplateRequest to receive environment template action requests
+type EnvTemplateRequest struct {
+	CSRFToken    string                      `json:"csrftoken"`
+	Action       string                      `json:"action"`
+	Name         string                      `json:"name"`
+	Description  string                      `json:"description"`
+	Environment  string                      `json:"environment"`
+	Config       environments.TemplateConfig `json:"config"`
+	Note         string                      `json:"note"`
+	Environments []string                    `json:"environments"`
+}
+
+// RejectionsRequest to receive approvals of quarantined or flagged nodes
+type RejectionsRequest struct {
 	CSRFToken string `json:"csrftoken"`
 	Action    string `json:"action"`
-	UUID      string `json:"uuid"`
-	Name      string `json:"name"`
-	Hostname  string `json:"hostname"`
-	Type      string `json:"type"`
-	Icon      string `json:"icon"`
-	DebugHTTP bool   `json:"debughttp"`
+	ID        uint   `json:"id"`
+}
+
+// RollbackRequest to receive rollbacks of the configuration of an environment to a version
+type RollbackRequest struct {
+	CSRFToken string `json:"csrftoken"`
+	Version   int    `json:"version"`
+	Note      string `json:"note"`
+}
+
+// ScheduledRequest to receive the cancellation of a scheduled configuration change
+type ScheduledRequest struct {
+	CSRFToken string `json:"csrftoken"`
 }
 
 // UsersRequest to receive user action requests
@@ -120,6 +503,7 @@ type UsersRequest struct {
 	Token       bool   `json:"token"`
 	Admin       bool   `json:"admin"`
 	DefaultEnv  string `json:"environment"`
+	IPAddress   string `json:"ip_address"`
 }
 
 // TagsRequest to receive tag action requests
@@ -138,6 +522,15 @@ type TagNodesRequest struct {
 	UUIDs      []string `json:"uuids"`
 	TagsAdd    []string `json:"tagsadd"`
 	TagsRemove []string `json:"tagsremove"`
+	Confirm    bool     `json:"confirm"`
+}
+
+// TagNodesResponse to return the job tagging nodes, or that the operation needs confirmation
+type TagNodesResponse struct {
+	Message string `json:"message"`
+	JobID   uint   `json:"job"`
+	Total   int    `json:"total"`
+	Confirm bool   `json:"confirm"`
 }
 
 // PermissionsRequest to receive user permissions changes requests
@@ -150,6 +543,15 @@ type PermissionsRequest struct {
 	Admin       bool   `json:"admin"`
 }
 
+// PermissionsBulkRequest to receive requests to change user permissions in multiple environments
+type PermissionsBulkRequest struct {
+	CSRFToken    string   `json:"csrftoken"`
+	Action       string   `json:"action"`
+	Environments []string `json:"environments"`
+	Level        string   `json:"level"`
+	FromUser     string   `json:"from_user"`
+}
+
 // AdminResponse to be returned to requests
 type AdminResponse struct {
 	Message string `json:"message"`
@@ -181,3 +583,88 @@ type SavedQueryRequest struct {
 	Name      string `json:"name"`
 	Query     string `json:"query"`
 }
+
+// ReportsRequest to receive report generation and schedule requests
+type ReportsRequest struct {
+	CSRFToken   string `json:"csrftoken"`
+	Action      string `json:"action"`
+	ID          uint   `json:"id"`
+	Environment string `json:"environment"`
+	Category    string `json:"category"`
+	Period      string `json:"period"`
+	Format      string `json:"format"`
+	Frequency   string `json:"frequency"`
+	Recipients  string `json:"recipients"`
+}
+
+// QueryTemplatesRequest to receive query template requests, with one variable per line as name:type[:values]
+type QueryTemplatesRequest struct {
+	CSRFToken   string `json:"csrftoken"`
+	Action      string `json:"action"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Query       string `json:"query"`
+	Variables   string `json:"variables"`
+	Platforms   string `json:"platforms"`
+	Risk        string `json:"risk"`
+	Team        string `json:"team"`
+	Approved    bool   `json:"approved"`
+	Changelog   string `json:"changelog"`
+}
+
+// DeadLettersRequest to receive replay and purge requests for dead letters
+type DeadLettersRequest struct {
+	CSRFToken string `json:"csrftoken"`
+	Action    string `json:"action"`
+	IDs       []uint `json:"ids"`
+	Backend   string `json:"backend"`
+	Reason    string `json:"reason"`
+	Target    string `json:"target"`
+}
+
+// NoticeRequest to receive changes to notices and acknowledgments of users
+type NoticeRequest struct {
+	CSRFToken      string   `json:"csrftoken"`
+	Action         string   `json:"action"`
+	ID             uint     `json:"id"`
+	Message        string   `json:"message"`
+	Severity       string   `json:"severity"`
+	Environments   []string `json:"environments"`
+	ExpiresAt      string   `json:"expires_at"`
+	RequireAck     bool     `json:"require_ack"`
+	ConfirmActions bool     `json:"confirm_actions"`
+}
+
+// TrashRequest to receive requests to restore items from the trash or delete them permanently
+type TrashRequest struct {
+	CSRFToken   string `json:"csrftoken"`
+	Action      string `json:"action"`
+	Kind        string `json:"kind"`
+	Name        string `json:"name"`
+	Environment string `json:"environment"`
+}
+
+// RecurringCarveRequest to receive requests to create, pause, resume and stop recurring carves
+type RecurringCarveRequest struct {
+	CSRFToken  string `json:"csrftoken"`
+	Action     string `json:"action"`
+	Name       string `json:"name"`
+	CaseID     string `json:"case_id"`
+	Paths      string `json:"paths"`
+	UUIDs      string `json:"uuids"`
+	Recurrence string `json:"recurrence"`
+	EndAt      string `json:"end_at"`
+}
+
+// SetupRequest to receive the first-run setup, from the wizard or from automated installs
+type SetupRequest struct {
+	Token         string `json:"token"`
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	Email         string `json:"email"`
+	Fullname      string `json:"fullname"`
+	Environment   string `json:"environment"`
+	Hostname      string `json:"hostname"`
+	Template      string `json:"template"`
+	InactiveHours int64  `json:"inactive_hours"`
+}
@@ -1,20 +1,48 @@
 package handlers
 
 import (
+	"time"
+
+	"github.com/jmpsec/osctrl/backend"
 	"github.com/jmpsec/osctrl/carves"
 	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/logging"
+	"github.com/jmpsec/osctrl/metrics"
 	"github.com/jmpsec/osctrl/nodes"
 	"github.com/jmpsec/osctrl/queries"
+	"github.com/jmpsec/osctrl/reports"
+	"github.com/jmpsec/osctrl/responses"
 	"github.com/jmpsec/osctrl/settings"
 	"github.com/jmpsec/osctrl/tags"
 	"github.com/jmpsec/osctrl/types"
 	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/webhooks"
 )
 
+// StatusTemplateData for passing data to the node status template
+type StatusTemplateData struct {
+	Title    string
+	Status   nodes.NodeStatus
+	Metadata TemplateMetadata
+}
+
 // LoginTemplateData for passing data to the login template
 type LoginTemplateData struct {
-	Title   string
-	Project string
+	Title    string
+	Project  string
+	Metadata TemplateMetadata
+}
+
+// SetupTemplateData for passing data to the first-run setup template
+type SetupTemplateData struct {
+	Title         string
+	Project       string
+	Token         string
+	State         users.SetupState
+	Templates     []environments.EnvTemplate
+	InactiveHours int64
+	MinPassword   int
+	Metadata      TemplateMetadata
 }
 
 // TemplateMetadata to pass some metadata to templates
@@ -23,12 +51,23 @@ type TemplateMetadata struct {
 	Level          string
 	Service        string
 	Version        string
+	Commit         string
+	BuildDate      string
 	TLSDebug       bool
 	AdminDebug     bool
 	APIDebug       bool
 	AdminDebugHTTP bool
 	APIDebugHTTP   bool
 	CSRFToken      string
+	URLPrefix      string
+	EnvUUID        string
+	Notices        []users.Notice
+	ConfirmNotices []users.Notice
+	Quota          environments.StorageQuota
+	EnvCategory    string
+	// Carve actions are only shown in the environments where the user can carve files
+	Carve     bool
+	CarveEnvs map[string]bool
 }
 
 // AsideLeftMetadata to pass metadata to the aside left menu
@@ -51,6 +90,8 @@ type TableTemplateData struct {
 	SelectorName string
 	Target       string
 	Tags         []tags.AdminTag
+	Storm        environments.StormState
+	QuickActions []queries.QuickAction
 	Environments []environments.TLSEnvironment
 	Platforms    []string
 	Metadata     TemplateMetadata
@@ -63,6 +104,29 @@ type ConfTemplateData struct {
 	Environment  environments.TLSEnvironment
 	Environments []environments.TLSEnvironment
 	Platforms    []string
+	Overrides    []environments.ScheduleOverride
+	Profiles     []environments.PerformanceProfile
+	Denylist     []environments.ScheduleDeny
+	DenyCands    []environments.DenyCandidate
+	DenyPreview  string
+	QuickActions []queries.QuickAction
+	QuickTypes   []string
+	Approved     []queries.QueryTemplate
+	WinEvents    environments.WindowsEventsPreview
+	WinSchedule  string
+	WinExport    string
+	APIKeys      []users.APIKey
+	APIKeyScopes []string
+	Versions     []environments.ConfigVersion
+	Scheduled    environments.ScheduledChange
+	HasScheduled bool
+	Template     environments.EnvTemplate
+	Drift        environments.TemplateDiff
+	Conflicts    []environments.ConfigConflict
+	Storage      environments.StorageReport
+	StorageDaily []environments.StorageSample
+	Categories   []string
+	QuotaActions []string
 	Metadata     TemplateMetadata
 	LeftMetadata AsideLeftMetadata
 }
@@ -84,6 +148,14 @@ type EnrollTemplateData struct {
 	Secret                string
 	Flags                 string
 	Certificate           string
+	CertificateStatus     environments.CertificateStatus
+	EnrollFields          []environments.EnrollField
+	Locale                string
+	OsqueryPin            string
+	OsqueryPins           string
+	OsqueryPackages       []environments.OsqueryPackage
+	OsqueryVersions       nodes.VersionReport
+	FlagWarnings          []string
 	Environments          []environments.TLSEnvironment
 	Platforms             []string
 	Metadata              TemplateMetadata
@@ -92,16 +164,21 @@ type EnrollTemplateData struct {
 
 // QueryRunTemplateData for passing data to the query run template
 type QueryRunTemplateData struct {
-	Title         string
-	EnvUUID       string
-	Environments  []environments.TLSEnvironment
-	Platforms     []string
-	UUIDs         []string
-	Hosts         []string
-	Tables        []types.OsqueryTable
-	TablesVersion string
-	Metadata      TemplateMetadata
-	LeftMetadata  AsideLeftMetadata
+	Title          string
+	EnvUUID        string
+	Environments   []environments.TLSEnvironment
+	Platforms      []string
+	EnvPlatforms   []string
+	UUIDs          []string
+	Hosts          []string
+	Tables         []types.OsqueryTable
+	TablesVersion  string
+	QueryTemplates []QueryTemplateView
+	MaxLabels      int
+	Groups         []string
+	Expiration     queries.ExpirationRange
+	Metadata       TemplateMetadata
+	LeftMetadata   AsideLeftMetadata
 }
 
 // CarvesRunTemplateData for passing data to the carves run template
@@ -124,8 +201,18 @@ type QueryTableTemplateData GenericTableTemplateData
 // SavedQueriesTemplateData for passing data to the saved queries
 type SavedQueriesTemplateData GenericTableTemplateData
 
-// CarvesTableTemplateData for passing data to the carves template
-type CarvesTableTemplateData GenericTableTemplateData
+// CarvesTableTemplateData for passing data to the carves template, with the use of carves against the limits
+type CarvesTableTemplateData struct {
+	Title        string
+	EnvUUID      string
+	Environments []environments.TLSEnvironment
+	Platforms    []string
+	Target       string
+	Usage        carves.CarveUsage
+	Rejections   []carves.CarveRejection
+	Metadata     TemplateMetadata
+	LeftMetadata AsideLeftMetadata
+}
 
 // CarvesDetailsTemplateData for passing data to the carves details
 type CarvesDetailsTemplateData struct {
@@ -135,8 +222,14 @@ type CarvesDetailsTemplateData struct {
 	Platforms    []string
 	Query        queries.DistributedQuery
 	QueryTargets []queries.DistributedQueryTarget
+	Window       queries.TargetWindow
 	Carves       []carves.CarvedFile
 	CarveBlocks  map[string][]carves.CarvedBlock
+	ScanPolicy   string
+	Scanner      bool
+	Downloads    map[string]string
+	Manifests    map[string][]carves.ManifestNode
+	Accesses     []environments.DataAccess
 	Metadata     TemplateMetadata
 	LeftMetadata AsideLeftMetadata
 }
@@ -149,6 +242,37 @@ type QueryLogsTemplateData struct {
 	Platforms    []string
 	Query        queries.DistributedQuery
 	QueryTargets []queries.DistributedQueryTarget
+	Window       queries.TargetWindow
+	CanEdit      bool
+	Shares       []queries.ShareExport
+	Exports      []queries.ExportJob
+	Archive      queries.QueryArchive
+	DenyColumns  string
+	Accesses     []environments.DataAccess
+	Metadata     TemplateMetadata
+	LeftMetadata AsideLeftMetadata
+}
+
+// QueryLockedTemplateData for passing data to the template of queries with results the user can not see
+type QueryLockedTemplateData struct {
+	Title         string
+	EnvUUID       string
+	Environments  []environments.TLSEnvironment
+	Platforms     []string
+	Query         queries.DistributedQuery
+	CanBreakGlass bool
+	Metadata      TemplateMetadata
+	LeftMetadata  AsideLeftMetadata
+}
+
+// QueryProfileTemplateData for passing data to the query profile template
+type QueryProfileTemplateData struct {
+	Title        string
+	EnvUUID      string
+	Environments []environments.TLSEnvironment
+	Platforms    []string
+	QueryTargets []queries.DistributedQueryTarget
+	Report       queries.ProfileReport
 	Metadata     TemplateMetadata
 	LeftMetadata AsideLeftMetadata
 }
@@ -156,6 +280,49 @@ type QueryLogsTemplateData struct {
 // EnvironmentsTemplateData for passing data to the environments template
 type EnvironmentsTemplateData struct {
 	Title        string
+	Storms       map[uint]environments.StormState
+	Storage      map[uint]environments.StorageUsage
+	Quotas       map[uint]environments.StorageQuota
+	Locales      map[string]string
+	Templates    []environments.EnvTemplate
+	Categories   []string
+	Policies     []environments.CategoryPolicy
+	Environments []environments.TLSEnvironment
+	Platforms    []string
+	Metadata     TemplateMetadata
+	LeftMetadata AsideLeftMetadata
+}
+
+// EnvTemplatesTemplateData for passing data to the environment templates template
+type EnvTemplatesTemplateData struct {
+	Title        string
+	Templates    []environments.EnvTemplate
+	Derived      map[uint]int
+	Environments []environments.TLSEnvironment
+	Platforms    []string
+	Metadata     TemplateMetadata
+	LeftMetadata AsideLeftMetadata
+}
+
+// EnvTemplateTemplateData for passing data to the template of one environment template
+type EnvTemplateTemplateData struct {
+	Title        string
+	Template     environments.EnvTemplate
+	Propagation  []environments.TemplateDiff
+	Locales      map[string]string
+	Environments []environments.TLSEnvironment
+	Platforms    []string
+	Metadata     TemplateMetadata
+	LeftMetadata AsideLeftMetadata
+}
+
+// SearchTemplateData for passing data to the node search template
+type SearchTemplateData struct {
+	Title        string
+	Term         string
+	Error        string
+	Matches      []nodes.NodeMatch
+	EnvUUIDs     map[uint]string
 	Environments []environments.TLSEnvironment
 	Platforms    []string
 	Metadata     TemplateMetadata
@@ -170,6 +337,10 @@ type SettingsTemplateData struct {
 	Platforms       []string
 	CurrentSettings []settings.SettingValue
 	ServiceConfig   types.JSONConfigurationAdmin
+	AlwaysTypes     string
+	AlwaysNames     string
+	AlwaysDisabled  string
+	AlwaysEstimate  logging.AlwaysEstimate
 	Metadata        TemplateMetadata
 	LeftMetadata    AsideLeftMetadata
 }
@@ -180,6 +351,8 @@ type UsersTemplateData struct {
 	Environments []environments.TLSEnvironment
 	Platforms    []string
 	CurrentUsers []users.AdminUser
+	Lockouts     []users.LoginLockout
+	Teams        map[string][]string
 	Metadata     TemplateMetadata
 	LeftMetadata AsideLeftMetadata
 }
@@ -204,18 +377,418 @@ type TagsTemplateData struct {
 	LeftMetadata AsideLeftMetadata
 }
 
+// ReportsTemplateData for passing data to the reports template
+type ReportsTemplateData struct {
+	Title        string
+	Environments []environments.TLSEnvironment
+	Platforms    []string
+	Reports      []reports.Report
+	Schedules    []reports.ReportSchedule
+	Categories   []string
+	PDFEnabled   bool
+	EmailEnabled bool
+	Metadata     TemplateMetadata
+	LeftMetadata AsideLeftMetadata
+}
+
+// DeadLettersTemplateData for passing data to the dead letters template
+type DeadLettersTemplateData struct {
+	Title        string
+	Counts       []logging.DeadLetterCount
+	Entries      []logging.DeadLetter
+	Targets      []string
+	Metadata     TemplateMetadata
+	LeftMetadata AsideLeftMetadata
+}
+
+// NoticesTemplateData for passing data to the notices template
+type NoticesTemplateData struct {
+	Title        string
+	Active       []users.Notice
+	History      []users.Notice
+	Acks         map[uint][]users.NoticeAck
+	Environments []environments.TLSEnvironment
+	Platforms    []string
+	EnvNames     map[string]string
+	Severities   []string
+	Metadata     TemplateMetadata
+	LeftMetadata AsideLeftMetadata
+}
+
+// ServiceHealthTemplateData for passing data to the service health template
+type ServiceHealthTemplateData struct {
+	Title        string
+	Window       string
+	Windows      []string
+	Instances    []metrics.RequestSnapshot
+	TopErrors    []metrics.RouteRequestStats
+	Slowest      []metrics.RouteRequestStats
+	Environments []metrics.EnvRequestStats
+	Routes       []metrics.RouteRequestStats
+	Metadata     TemplateMetadata
+	LeftMetadata AsideLeftMetadata
+}
+
 // NodeTemplateData for passing data to the query template
 type NodeTemplateData struct {
+	Title          string
+	EnvUUID        string
+	Node           nodes.OsqueryNode
+	NodeTags       []tags.AdminTag
+	TagsForNode    []tags.AdminTagForNode
+	Environments   []environments.TLSEnvironment
+	Platforms      []string
+	Metadata       TemplateMetadata
+	LeftMetadata   AsideLeftMetadata
+	Dashboard      bool
+	Schedule       environments.ScheduleConf
+	Packs          environments.PacksEntries
+	NetworkHistory []nodes.NodeHistoryIPAddress
+	Membership     []nodes.NodeMembership
+	Overrides      []environments.ScheduleOverride
+	ScheduleNames  []string
+	Decommission   nodes.NodeDecommission
+	RemoveOneLiner string
+	Pause          nodes.NodePause
+	Identity       NodeIdentityEntry
+	EnrollMetadata []EnrollMetadataEntry
+	Attributes     []nodes.NodeAttribute
+	Profile        NodeProfileEntry
+	StatusTokens   int64
+	ClockSkew      string
+	ClockSkewed    bool
+	QuickActions   []queries.QuickAction
+	Mutes          []responses.Mute
+	MuteKinds      []string
+	Now            time.Time
+}
+
+// NodeIdentityEntry to show the identity a node was bound to and the one it reports, with what changed
+type NodeIdentityEntry struct {
+	Bound    bool
+	Policy   string
+	Identity nodes.NodeIdentity
+	Original nodes.IdentityFingerprint
+	Current  nodes.IdentityFingerprint
+	Changes  []nodes.IdentityChange
+}
+
+// NodeProfileEntry to show the performance profile of a node and the intervals it gets
+type NodeProfileEntry struct {
+	Name           string
+	Set            bool
+	ConfigInterval int
+	LogInterval    int
+	QueryInterval  int
+}
+
+// EnrollMetadataEntry to show the value of an enroll metadata field for a node
+type EnrollMetadataEntry struct {
+	Name        string
+	Description string
+	Value       string
+	Set         bool
+	Defined     bool
+}
+
+// ActivityTemplateData for passing data to the activity template
+type ActivityTemplateData struct {
+	Title        string
+	EnvName      string
+	EnvUUID      string
+	Kinds        []string
+	Filter       environments.ActivityFilter
+	From         string
+	Kind         string
+	Entries      []environments.EnvActivity
+	Total        int64
+	PrevURL      string
+	NextURL      string
+	Environments []environments.TLSEnvironment
+	Platforms    []string
+	Metadata     TemplateMetadata
+	LeftMetadata AsideLeftMetadata
+}
+
+// AccessLogTemplateData for passing data to the data access report template
+type AccessLogTemplateData struct {
+	Title        string
+	EntityTypes  []string
+	Filter       environments.AccessFilter
+	Env          string
+	EntityType   string
+	From         string
+	To           string
+	Entries      []environments.DataAccess
+	Total        int64
+	PrevURL      string
+	NextURL      string
+	ExportURL    string
+	Environments []environments.TLSEnvironment
+	Platforms    []string
+	Metadata     TemplateMetadata
+	LeftMetadata AsideLeftMetadata
+}
+
+// ResponsesTemplateData for passing data to the response actions template
+type ResponsesTemplateData struct {
+	Title        string
+	EnvName      string
+	EnvUUID      string
+	Config       responses.ResponseConfig
+	Kinds        []string
+	Triggers     []responses.ResponseTrigger
+	Actions      []responses.ResponseAction
+	Templates    []queries.QueryTemplate
+	Mutes        []responses.Mute
+	MuteKinds    []string
+	Suppressed   int64
+	Now          time.Time
+	Environments []environments.TLSEnvironment
+	Platforms    []string
+	Metadata     TemplateMetadata
+	LeftMetadata AsideLeftMetadata
+}
+
+// WebhooksTemplateData for passing data to the webhooks template
+type WebhooksTemplateData struct {
+	Title        string
+	EnvName      string
+	EnvUUID      string
+	Endpoints    []webhooks.WebhookEndpoint
+	Failed       map[uint]int64
+	Events       []string
+	Selected     webhooks.WebhookEndpoint
+	State        string
+	Deliveries   []webhooks.WebhookDelivery
+	EntityType   string
+	EntityID     string
+	Attempts     []webhooks.WebhookAttempt
+	Now          time.Time
+	Environments []environments.TLSEnvironment
+	Platforms    []string
+	Metadata     TemplateMetadata
+	LeftMetadata AsideLeftMetadata
+}
+
+// PackSyncTemplateData for passing data to the pack sync template
+type PackSyncTemplateData struct {
+	Title        string
+	EnvName      string
+	EnvUUID      string
+	HasSource    bool
+	Source       environments.PackSource
+	Packs        []environments.PackSyncPack
+	Conflicts    int
+	AuthKinds    []string
+	Environments []environments.TLSEnvironment
+	Platforms    []string
+	Metadata     TemplateMetadata
+	LeftMetadata AsideLeftMetadata
+}
+
+// RejectionsTemplateData for passing data to the rejected enrollments template
+type RejectionsTemplateData struct {
+	Title        string
+	EnvName      string
+	EnvUUID      string
+	Allowed      string
+	Policy       string
+	Rejections   []nodes.EnrollRejection
+	Environments []environments.TLSEnvironment
+	Platforms    []string
+	Metadata     TemplateMetadata
+	LeftMetadata AsideLeftMetadata
+}
+
+// EnrollLoopsTemplateData for passing data to the enroll loops template
+type EnrollLoopsTemplateData struct {
+	Title        string
+	EnvName      string
+	EnvUUID      string
+	Config       nodes.EnrollLoopConfig
+	Loops        []nodes.EnrollLoop
+	Now          time.Time
+	Environments []environments.TLSEnvironment
+	Platforms    []string
+	Metadata     TemplateMetadata
+	LeftMetadata AsideLeftMetadata
+}
+
+// NodeMergesTemplateData for passing data to the duplicate nodes template
+type NodeMergesTemplateData struct {
+	Title        string
+	EnvName      string
+	EnvUUID      string
+	State        string
+	Thresholds   nodes.MergeThresholds
+	Window       time.Duration
+	Merges       []nodes.NodeMerge
+	Now          time.Time
+	Environments []environments.TLSEnvironment
+	Platforms    []string
+	Metadata     TemplateMetadata
+	LeftMetadata AsideLeftMetadata
+}
+
+// ActivityDiffTemplateData for passing data to the activity diff template
+type ActivityDiffTemplateData struct {
+	Title        string
+	EnvName      string
+	EnvUUID      string
+	Entry        environments.EnvActivity
+	Diff         []environments.DiffLine
+	Environments []environments.TLSEnvironment
+	Platforms    []string
+	Metadata     TemplateMetadata
+	LeftMetadata AsideLeftMetadata
+}
+
+// ConfVersionsTemplateData for passing data to the configuration versions diff template
+type ConfVersionsTemplateData struct {
+	Title        string
+	EnvName      string
+	EnvUUID      string
+	From         environments.ConfigVersion
+	To           environments.ConfigVersion
+	Diffs        []environments.VersionDiff
+	Versions     []environments.ConfigVersion
+	Environments []environments.TLSEnvironment
+	Platforms    []string
+	Metadata     TemplateMetadata
+	LeftMetadata AsideLeftMetadata
+}
+
+// NodeConfigHistoryTemplateData for passing data to the configuration lineage template of a node
+type NodeConfigHistoryTemplateData struct {
 	Title        string
 	EnvUUID      string
 	Node         nodes.OsqueryNode
-	NodeTags     []tags.AdminTag
-	TagsForNode  []tags.AdminTagForNode
+	Lineage      []environments.NodeConfigLineage
+	Diff         environments.LineageDiff
+	Environments []environments.TLSEnvironment
+	Platforms    []string
+	Metadata     TemplateMetadata
+	LeftMetadata AsideLeftMetadata
+}
+
+// RecurringCell to show what happened in one node for one occurrence of a recurring carve
+type RecurringCell struct {
+	Status    string
+	Detail    string
+	QueryName string
+}
+
+// RecurringGridRow to show one occurrence of a recurring carve, with one cell per node
+type RecurringGridRow struct {
+	Occurrence time.Time
+	Cells      []RecurringCell
+}
+
+// RecurringGrid to show the occurrences of a recurring carve by node, newest first
+type RecurringGrid struct {
+	Nodes []string
+	Rows  []RecurringGridRow
+}
+
+// RecurringCarvesTemplateData for passing data to the recurring carves template
+type RecurringCarvesTemplateData struct {
+	Title        string
+	EnvName      string
+	EnvUUID      string
+	Recurring    []queries.RecurringCarveDetail
+	Selected     *queries.RecurringCarveDetail
+	Grid         RecurringGrid
+	Environments []environments.TLSEnvironment
+	Platforms    []string
+	Metadata     TemplateMetadata
+	LeftMetadata AsideLeftMetadata
+}
+
+// TrashTemplateData for passing data to the trash template
+type TrashTemplateData struct {
+	Title        string
+	EnvName      string
+	EnvUUID      string
+	Items        []backend.TrashItem
+	Days         int
+	Environments []environments.TLSEnvironment
+	Platforms    []string
+	Metadata     TemplateMetadata
+	LeftMetadata AsideLeftMetadata
+}
+
+// QueryTemplateView to pass a query template with its variables and versions to templates
+type QueryTemplateView struct {
+	Name        string                         `json:"name"`
+	Description string                         `json:"description"`
+	Query       string                         `json:"query"`
+	Variables   []queries.TemplateVariable     `json:"variables"`
+	Platforms   []string                       `json:"platforms"`
+	Risk        string                         `json:"risk"`
+	Team        string                         `json:"team"`
+	Approved    bool                           `json:"approved"`
+	Version     int                            `json:"version"`
+	Versions    []queries.QueryTemplateVersion `json:"-"`
+}
+
+// QueryTemplatesTemplateData for passing data to the query templates template
+type QueryTemplatesTemplateData struct {
+	Title        string
+	Environments []environments.TLSEnvironment
+	Platforms    []string
+	Templates    []QueryTemplateView
+	Risks        []string
+	CanModify    bool
+	Metadata     TemplateMetadata
+	LeftMetadata AsideLeftMetadata
+}
+
+// CompareSectionView to pass a page of the differences of a section with the pages around it
+type CompareSectionView struct {
+	nodes.SectionDiff
+	Pages int
+	Prev  int
+	Next  int
+}
+
+// NodeCompareTemplateData for passing data to the compare nodes template
+type NodeCompareTemplateData struct {
+	Title        string
+	A            string
+	B            string
+	Section      string
+	Error        string
+	NodeA        nodes.NodeSnapshot
+	NodeB        nodes.NodeSnapshot
+	Sections     []CompareSectionView
+	Environments []environments.TLSEnvironment
+	Platforms    []string
+	Metadata     TemplateMetadata
+	LeftMetadata AsideLeftMetadata
+}
+
+// QueryPoliciesTemplateData for passing data to the query policies template
+type QueryPoliciesTemplateData struct {
+	Title        string
+	EnvName      string
+	EnvUUID      string
+	Policies     []queries.QueryPolicy
+	Tables       []types.OsqueryTable
+	Environments []environments.TLSEnvironment
+	Platforms    []string
+	Metadata     TemplateMetadata
+	LeftMetadata AsideLeftMetadata
+}
+
+// QueryGroupsTemplateData for passing data to the query groups template
+type QueryGroupsTemplateData struct {
+	Title        string
+	EnvName      string
+	EnvUUID      string
+	Groups       []queries.GroupUtilization
 	Environments []environments.TLSEnvironment
 	Platforms    []string
 	Metadata     TemplateMetadata
 	LeftMetadata AsideLeftMetadata
-	Dashboard    bool
-	Schedule     environments.ScheduleConf
-	Packs        environments.PacksEntries
 }
@@ -2,18 +2,26 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
 	"crypto/rand"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/jmpsec/osctrl/cache"
 	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/logging"
+	"github.com/jmpsec/osctrl/nodes"
 	"github.com/jmpsec/osctrl/queries"
 	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/tags"
 	"github.com/jmpsec/osctrl/types"
 	"github.com/jmpsec/osctrl/users"
 	"github.com/jmpsec/osctrl/utils"
@@ -171,6 +179,9 @@ func toJSONConfigurationService(values []settings.SettingValue) types.JSONConfig
 		if v.Name == settings.JSONSessionKey {
 			cfg.SessionKey = v.String
 		}
+		if v.Name == settings.JSONURLPrefix {
+			cfg.URLPrefix = v.String
+		}
 	}
 	return cfg
 }
@@ -178,7 +189,7 @@ func toJSONConfigurationService(values []settings.SettingValue) types.JSONConfig
 // Helper to generate a link to results for on-demand queries
 func (h *HandlersAdmin) queryResultLink(name string, env string) string {
 	replacer := strings.NewReplacer("{{ENV}}", env, "{{NAME}}", removeBackslash(name))
-	return replacer.Replace(QueryLink)
+	return h.URLPrefix + replacer.Replace(QueryLink)
 }
 
 // Helper to convert the list of all TLS environments with the ones with permissions for a user
@@ -191,3 +202,391 @@ func (h *HandlersAdmin) allowedEnvironments(username string, allEnvs []environme
 	}
 	return envs
 }
+
+// Helper to get the UUIDs of the environments where a user can carve files, so carve actions are only shown there
+func (h *HandlersAdmin) carveEnvironments(username string) map[string]bool {
+	carve := make(map[string]bool)
+	access, err := h.Users.GetAccess(username)
+	if err != nil {
+		log.Printf("error getting access for %s %v", username, err)
+		return carve
+	}
+	for env, a := range access {
+		if a.CanCarve() {
+			carve[env] = true
+		}
+	}
+	return carve
+}
+
+// Helper to estimate how much of the last hour traffic would be written by the always logger
+func (h *HandlersAdmin) alwaysEstimate(filters logging.AlwaysFilters) (logging.AlwaysEstimate, error) {
+	// Traffic is only sampled when the always logger is enabled in the TLS service
+	if !h.DB.Migrator().HasTable(&logging.AlwaysLogTraffic{}) {
+		return logging.AlwaysEstimate{}, nil
+	}
+	return logging.EstimateAlways(h.DB, filters, time.Now().Add(-time.Hour))
+}
+
+// Helper to prepare the enroll metadata of a node, with the defined fields first and then stored values of fields no longer defined
+func enrollMetadataEntries(fields []environments.EnrollField, values map[string]string) []EnrollMetadataEntry {
+	var entries []EnrollMetadataEntry
+	defined := make(map[string]bool)
+	for _, f := range fields {
+		defined[f.Name] = true
+		value, ok := values[f.Name]
+		entries = append(entries, EnrollMetadataEntry{
+			Name:        f.Name,
+			Description: f.Description,
+			Value:       value,
+			Set:         ok,
+			Defined:     true,
+		})
+	}
+	var others []string
+	for name := range values {
+		if !defined[name] {
+			others = append(others, name)
+		}
+	}
+	sort.Strings(others)
+	for _, name := range others {
+		entries = append(entries, EnrollMetadataEntry{Name: name, Value: values[name], Set: true})
+	}
+	return entries
+}
+
+// Helper to prepare the performance profile of a node, with the intervals it gets from the profile or the environment
+func nodeProfileEntry(env environments.TLSEnvironment, profiles []environments.PerformanceProfile, node nodes.OsqueryNode, nodeTags []tags.AdminTag) NodeProfileEntry {
+	pNode := environments.ProfileNode{
+		Platform:      node.Platform,
+		HardwareModel: nodes.HardwareModel(node),
+	}
+	for _, t := range nodeTags {
+		pNode.Tags = append(pNode.Tags, t.Name)
+	}
+	profile, ok := environments.MatchProfile(profiles, pNode)
+	effective := env
+	if ok {
+		effective = env.WithProfile(profile)
+	}
+	return NodeProfileEntry{
+		Name:           profile.Name,
+		Set:            ok,
+		ConfigInterval: effective.ConfigInterval,
+		LogInterval:    effective.LogInterval,
+		QueryInterval:  effective.QueryInterval,
+	}
+}
+
+// Past tense of the actions on enroll and remove links, for the activity of environments
+var linkActivity = map[string]string{
+	"expire":    "expired",
+	"extend":    "extended",
+	"rotate":    "regenerated",
+	"notexpire": "set to not expire",
+}
+
+// Helper to record a change of an environment in its activity, errors are only logged
+func (h *HandlersAdmin) recordChange(env environments.TLSEnvironment, kind, actor, summary string) {
+	h.invalidateResponses(cache.ResponseGroupEnvironments)
+	h.publishEvent(cache.EventEnvironment, env.UUID, kind)
+	if err := h.Envs.RecordChange(env, kind, actor, summary); err != nil {
+		log.Printf("error recording activity for %s %v", env.Name, err)
+	}
+}
+
+// Helper to get the section of the configuration and its decoded value sent in a request, empty if none was sent
+func configurationSection(c ConfigurationRequest) (string, string, error) {
+	sections := []struct {
+		name  string
+		value string
+	}{
+		{environments.ActivityConfiguration, c.ConfigurationB64},
+		{environments.ActivityOptions, c.OptionsB64},
+		{environments.ActivitySchedule, c.ScheduleB64},
+		{environments.ActivityPacks, c.PacksB64},
+		{environments.ActivityDecorators, c.DecoratorsB64},
+		{environments.ActivityATC, c.ATCB64},
+	}
+	for _, s := range sections {
+		if s.value == "" {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(s.value)
+		if err != nil {
+			return s.name, "", fmt.Errorf("error decoding %s - %v", s.name, err)
+		}
+		return s.name, string(decoded), nil
+	}
+	return "", "", nil
+}
+
+// Helper to calculate the active nodes receiving a change of one section of the configuration of an environment
+func (h *HandlersAdmin) configImpact(env environments.TLSEnvironment, section, value string) (environments.ConfigImpact, error) {
+	proposed, err := h.Envs.ProposedConfig(env, section, value)
+	if err != nil {
+		return environments.ConfigImpact{}, err
+	}
+	// Active nodes come from the node counts snapshots, not from counting nodes
+	counts, day, err := h.Nodes.CachedCounts(env.ID, h.Settings.InactiveHours())
+	if err != nil {
+		return environments.ConfigImpact{}, err
+	}
+	impact, err := h.Envs.ConfigImpact(env, proposed, nodes.ActivePlatforms(counts))
+	impact.CountsDay = day
+	return impact, err
+}
+
+// Helper to check the conflicts of a change of one section of the configuration of an environment, before saving it
+func (h *HandlersAdmin) configConflicts(env environments.TLSEnvironment, section, value string) ([]string, error) {
+	proposed, err := h.Envs.ProposedServed(env, section, value)
+	if err != nil {
+		return nil, err
+	}
+	warnings, err := environments.ValidateConflicts(proposed, h.Settings.ConfigConflicts(), h.Settings.QueryBudget())
+	if err != nil {
+		return warnings, err
+	}
+	// Flags and options not accepted by the osquery versions of the nodes are shown, not enforced
+	report, err := h.verifyConfig(context.Background(), proposed)
+	if err != nil {
+		log.Printf("error verifying configuration for %s %v", env.Name, err)
+		return warnings, nil
+	}
+	return append(warnings, report.Failures()...), nil
+}
+
+// Helper to verify the flags and configuration served by an environment against the osquery versions of its nodes,
+// and with a local osqueryd when configured
+func (h *HandlersAdmin) verifyConfig(ctx context.Context, env environments.TLSEnvironment) (environments.VerifyReport, error) {
+	versions, err := h.Nodes.GetVersionReport(env.ID, func(string) string { return "" })
+	if err != nil {
+		return environments.VerifyReport{}, err
+	}
+	var targets []environments.VerifyTarget
+	for _, v := range versions.Versions {
+		targets = append(targets, environments.VerifyTarget{Platform: v.Platform, Version: v.Version})
+	}
+	channels, err := h.Envs.WindowsEventsCtx(ctx, env.ID)
+	if err != nil {
+		return environments.VerifyReport{}, err
+	}
+	checker := environments.NewOsquerydChecker(h.Settings.OsquerydCheckPath())
+	return h.Envs.VerifyServed(ctx, env, environments.PerformanceProfile{}, channels, environments.FleetTargets(env, targets), checker)
+}
+
+// Helper to add the warnings of conflicts and verification to the message of a saved configuration
+func conflictsMessage(msg string, warnings []string) string {
+	if len(warnings) == 0 {
+		return msg
+	}
+	return msg + ", with warnings: " + strings.Join(warnings, "; ")
+}
+
+// Helper to record a change of the configuration of an environment with its impact, errors are only logged
+func (h *HandlersAdmin) recordConfigChange(env environments.TLSEnvironment, kind, value, actor, summary string) {
+	impact, err := h.configImpact(env, kind, value)
+	if err != nil {
+		log.Printf("error calculating impact for %s %v", env.Name, err)
+		h.recordChange(env, kind, actor, summary)
+		return
+	}
+	h.invalidateResponses(cache.ResponseGroupEnvironments)
+	h.publishEvent(cache.EventEnvironment, env.UUID, kind)
+	if err := h.Envs.RecordConfigChange(env, kind, actor, summary, impact); err != nil {
+		log.Printf("error recording activity for %s %v", env.Name, err)
+	}
+}
+
+// Helper to discard the cached responses of the API for a group, errors are only logged
+func (h *HandlersAdmin) invalidateResponses(group string) {
+	if h.RedisCache == nil {
+		return
+	}
+	if err := h.RedisCache.InvalidateResponses(group); err != nil {
+		log.Printf("error invalidating cached responses %v", err)
+	}
+}
+
+// Helper to notify the TLS instances of a change, errors are only logged because they compare versions later.
+// Changes to environments also discard the environments map shared by TLS instances.
+func (h *HandlersAdmin) publishEvent(kind, environment, entity string) {
+	if kind == cache.EventEnvironment && h.RedisCache != nil {
+		if err := h.RedisCache.InvalidateEnvsCached(context.Background()); err != nil {
+			log.Printf("error invalidating cached environments %v", err)
+		}
+	}
+	if h.Events == nil {
+		return
+	}
+	if _, err := h.Events.Publish(context.Background(), kind, environment, entity); err != nil {
+		h.Inc(metricEventErr)
+		log.Printf("error publishing %s event %v", kind, err)
+		return
+	}
+	h.Inc(metricEventPub)
+}
+
+// Helper to create an environment, from a template when there is one, with the tag of the environment
+func (h *HandlersAdmin) createEnvironment(name, hostname, template, icon, envType, creator string) (environments.TLSEnvironment, error) {
+	var env environments.TLSEnvironment
+	if template != "" {
+		// All the configuration comes from the template, with fresh secrets and paths
+		created, err := h.Envs.CreateFromTemplate(template, name, hostname, creator)
+		if err != nil {
+			return env, fmt.Errorf("error creating environment from template - %v", err)
+		}
+		env = created
+	} else {
+		env = h.Envs.Empty(name, hostname)
+		env.Icon = icon
+		env.Type = envType
+		// Emtpy configuration
+		env.Configuration = h.Envs.GenEmptyConfiguration(true)
+		// Generate flags
+		flags, err := h.Envs.GenerateFlags(env, "", "")
+		if err != nil {
+			return env, fmt.Errorf("error generating flags - %v", err)
+		}
+		env.Flags = flags
+		if err := h.Envs.Create(env); err != nil {
+			return env, err
+		}
+	}
+	// Create a tag for this new environment
+	if !h.Tags.Exists(env.Name) {
+		if err := h.Tags.NewTag(env.Name, "Tag for environment "+env.Name, "", env.Icon, creator); err != nil {
+			return env, fmt.Errorf("error generating tag - %v", err)
+		}
+	}
+	return env, nil
+}
+
+// Helper to get the default expiration of the queries of an environment and the range allowed to override it
+func (h *HandlersAdmin) queryExpiration(env environments.TLSEnvironment) queries.ExpirationRange {
+	return queries.NewExpirationRange(int64(h.categoryPolicy(env).Expiration(env)), h.Settings.QueryExpirationMinHours(), h.Settings.QueryExpirationMaxHours())
+}
+
+// Helper to describe a storage quota for the activity of the environment
+func quotaSummary(q environments.StorageQuota) string {
+	if q.MaxBytes <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("quota: %d bytes\nactions: %s", q.MaxBytes, q.Actions)
+}
+
+// Helper to get the storage quota of an environment by UUID, errors are only logged
+func (h *HandlersAdmin) storageQuota(envUUID string) environments.StorageQuota {
+	if envUUID == "" {
+		return environments.StorageQuota{}
+	}
+	env, err := h.Envs.Get(envUUID)
+	if err != nil {
+		return environments.StorageQuota{}
+	}
+	quota, err := h.Envs.GetStorageQuota(env.ID)
+	if err != nil {
+		log.Printf("error getting storage quota %v", err)
+	}
+	return quota
+}
+
+// Helper to get the category of an environment for the badge in the header, empty without environment
+func (h *HandlersAdmin) envCategory(envUUID string) string {
+	if envUUID == "" {
+		return ""
+	}
+	env, err := h.Envs.Get(envUUID)
+	if err != nil {
+		return ""
+	}
+	return env.Category
+}
+
+// Helper to get the policy of the category of an environment, the default one if it fails to load
+func (h *HandlersAdmin) categoryPolicy(env environments.TLSEnvironment) environments.CategoryPolicy {
+	policy, err := h.Envs.CategoryPolicy(env.Category)
+	if err != nil {
+		log.Printf("error getting category policy %v", err)
+	}
+	return policy
+}
+
+// Helper to count the active nodes targeted by a launch of a query or carve, before it is created
+func (h *HandlersAdmin) launchTargets(envs, platforms, uuids, hosts []string) (int, error) {
+	targets := make(map[string]bool)
+	for _, e := range envs {
+		if e == "" || !h.Envs.Exists(e) {
+			continue
+		}
+		nodes, err := h.Nodes.GetByEnv(e, "active", h.Settings.InactiveHours())
+		if err != nil {
+			return 0, err
+		}
+		for _, n := range nodes {
+			targets[n.UUID] = true
+		}
+	}
+	for _, p := range platforms {
+		if p == "" {
+			continue
+		}
+		nodes, err := h.Nodes.GetByPlatform(p, "active", h.Settings.InactiveHours())
+		if err != nil {
+			return 0, err
+		}
+		for _, n := range nodes {
+			targets[n.UUID] = true
+		}
+	}
+	for _, t := range append(uuids, hosts...) {
+		if t != "" {
+			targets[t] = true
+		}
+	}
+	return len(targets), nil
+}
+
+// Helper to check if a launch of a query or carve is confirmed as the policy of the category of the environment asks
+func (h *HandlersAdmin) checkLaunch(env environments.TLSEnvironment, envs, platforms, uuids, hosts []string, typed string) error {
+	policy := h.categoryPolicy(env)
+	if policy.ConfirmTargets <= 0 {
+		return nil
+	}
+	targets, err := h.launchTargets(envs, platforms, uuids, hosts)
+	if err != nil {
+		return err
+	}
+	return policy.CheckLaunch(env, targets, typed)
+}
+
+// Helper to add the note of a change of the configuration to its summary
+func withNote(summary, note string) string {
+	if note = strings.TrimSpace(note); note != "" {
+		return summary + ": " + note
+	}
+	return summary
+}
+
+// Helper to record a change of the policy of a category in the activity of all its environments
+func (h *HandlersAdmin) recordCategoryPolicy(policy environments.CategoryPolicy, actor string) {
+	envAll, err := h.Envs.All()
+	if err != nil {
+		log.Printf("error getting environments %v", err)
+		return
+	}
+	summary := environments.PolicySummary(policy)
+	for _, env := range envAll {
+		if env.Category == policy.Category {
+			h.recordActivity(env, environments.ActivityCategory, actor, summary, "", "")
+		}
+	}
+}
+
+// Helper to record activity of an environment with the values before and after, errors are only logged
+func (h *HandlersAdmin) recordActivity(env environments.TLSEnvironment, kind, actor, summary, before, after string) {
+	if err := h.Envs.RecordActivity(env.ID, kind, actor, summary, before, after); err != nil {
+		log.Printf("error recording activity for %s %v", env.Name, err)
+	}
+}
@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/admin/sessions"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+// Helper to find a version by number in a list of versions, newest first
+func findVersion(versions []environments.ConfigVersion, value string) (environments.ConfigVersion, bool) {
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return environments.ConfigVersion{}, false
+	}
+	for _, v := range versions {
+		if v.Version == number {
+			return v, true
+		}
+	}
+	return environments.ConfigVersion{}, false
+}
+
+// ConfVersionsGETHandler for GET requests for /conf/{environment}/versions to compare two versions
+func (h *HandlersAdmin) ConfVersionsGETHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), false)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["environment"]
+	if !ok {
+		h.Inc(metricAdminErr)
+		log.Println("error getting environment")
+		return
+	}
+	// Get environment
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environment: %v", err)
+		return
+	}
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Check permissions
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.UserLevel, env.UUID) {
+		log.Printf("%s has insuficient permissions", ctx[sessions.CtxUser])
+		h.Inc(metricAdminErr)
+		return
+	}
+	versions, err := h.Envs.ConfigVersions(env.ID)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting configuration versions: %v", err)
+		return
+	}
+	if len(versions) == 0 {
+		h.Inc(metricAdminErr)
+		log.Printf("no configuration versions for %s", env.Name)
+		return
+	}
+	// Missing versions compare the latest with the one before
+	q := r.URL.Query()
+	to, ok := findVersion(versions, q.Get("to"))
+	if !ok {
+		to = versions[0]
+	}
+	from, ok := findVersion(versions, q.Get("from"))
+	if !ok {
+		from = to
+		if len(versions) > 1 {
+			from = versions[1]
+		}
+	}
+	// Prepare template
+	tempateFiles := h.NewTemplateFiles(h.TemplatesFolder, "conf-versions.html").filepaths
+	t, err := template.ParseFiles(tempateFiles...)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting conf versions template: %v", err)
+		return
+	}
+	// Get stats for all environments
+	envAll, err := h.Envs.AllCtx(r.Context())
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environments %v", err)
+		return
+	}
+	// Get stats for all platforms
+	platforms, err := h.Nodes.GetAllPlatforms()
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting platforms: %v", err)
+		return
+	}
+	// Prepare template data
+	templateData := ConfVersionsTemplateData{
+		Title:        env.Name + " Configuration Versions",
+		EnvName:      env.Name,
+		EnvUUID:      env.UUID,
+		From:         from,
+		To:           to,
+		Diffs:        environments.DiffVersions(from, to),
+		Versions:     versions,
+		Metadata:     h.EnvTemplateMetadata(ctx, h.ServiceVersion, env.UUID),
+		LeftMetadata: AsideLeftMetadata{EnvUUID: env.UUID},
+		Environments: h.allowedEnvironments(ctx[sessions.CtxUser], envAll),
+		Platforms:    platforms,
+	}
+	if err := t.Execute(w, templateData); err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("template error %v", err)
+		return
+	}
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Conf versions template served")
+	}
+	h.Inc(metricAdminOK)
+}
+
+// ConfRollbackPOSTHandler for POST requests to rollback the configuration of an environment to a version
+func (h *HandlersAdmin) ConfRollbackPOSTHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), true)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["environment"]
+	if !ok {
+		h.Inc(metricAdminErr)
+		log.Println("error getting environment")
+		return
+	}
+	// Get environment
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environment: %v", err)
+		return
+	}
+	var f RollbackRequest
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Check permissions
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.AdminLevel, env.UUID) {
+		adminErrorResponse(w, fmt.Sprintf("%s has insuficient permissions", ctx[sessions.CtxUser]), http.StatusForbidden, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Parse request JSON body
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Decoding POST body")
+	}
+	if err := json.NewDecoder(r.Body).Decode(&f); err != nil {
+		adminErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Check CSRF Token
+	if !sessions.CheckCSRFToken(ctx[sessions.CtxCSRF], f.CSRFToken) {
+		adminErrorResponse(w, "invalid CSRF token", http.StatusInternalServerError, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Rollbacks need a note when the policy of the category of the environment asks for it
+	if err := h.categoryPolicy(env).CheckNote(f.Note); err != nil {
+		adminErrorResponse(w, err.Error(), http.StatusBadRequest, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	version, err := h.Envs.RollbackConfig(env.UUID, f.Version, ctx[sessions.CtxUser], f.Note)
+	if err != nil {
+		adminErrorResponse(w, "error rolling back configuration", http.StatusBadRequest, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	adminOKResponse(w, fmt.Sprintf("configuration rolled back to version %d as version %d", f.Version, version.Version))
+	// Serialize and send response
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Rollback response sent")
+	}
+	h.Inc(metricAdminOK)
+}
+
+// ConfImpactPOSTHandler for POST requests to preview the active nodes receiving a change of the configuration
+func (h *HandlersAdmin) ConfImpactPOSTHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), true)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["environment"]
+	if !ok {
+		h.Inc(metricAdminErr)
+		log.Println("error getting environment")
+		return
+	}
+	// Get environment
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environment: %v", err)
+		return
+	}
+	var c ConfigurationRequest
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Check permissions
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.AdminLevel, env.UUID) {
+		adminErrorResponse(w, fmt.Sprintf("%s has insuficient permissions", ctx[sessions.CtxUser]), http.StatusForbidden, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Parse request JSON body
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Decoding POST body")
+	}
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		adminErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Check CSRF Token
+	if !sessions.CheckCSRFToken(ctx[sessions.CtxCSRF], c.CSRFToken) {
+		adminErrorResponse(w, "invalid CSRF token", http.StatusInternalServerError, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	section, value, err := configurationSection(c)
+	if err != nil || section == "" {
+		adminErrorResponse(w, "error getting configuration", http.StatusBadRequest, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	impact, err := h.configImpact(env, section, value)
+	if err != nil {
+		adminErrorResponse(w, "error calculating impact", http.StatusBadRequest, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Preview the verification of the change against the osquery versions of the nodes
+	proposed, err := h.Envs.ProposedServed(env, section, value)
+	if err != nil {
+		adminErrorResponse(w, "error calculating impact", http.StatusBadRequest, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	if report, err := h.verifyConfig(r.Context(), proposed); err != nil {
+		log.Printf("error verifying configuration: %v", err)
+	} else {
+		impact.Verification = &report
+	}
+	// Serialize and send response
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Impact response sent")
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, impact)
+	h.Inc(metricAdminOK)
+}
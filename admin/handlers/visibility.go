@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/admin/sessions"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/queries"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+// Helper to get a user as a viewer of queries, with the teams of the user
+func (h *HandlersAdmin) viewer(username string) queries.Viewer {
+	teams, err := h.Users.UserTeams(username)
+	if err != nil {
+		log.Printf("error getting teams of %s %v", username, err)
+	}
+	return queries.Viewer{Username: username, Teams: teams}
+}
+
+// Helper to check if a user can see the results of a query, errors do not give access
+func (h *HandlersAdmin) canViewQuery(ctx context.Context, query queries.DistributedQuery, username string) bool {
+	ok, err := h.Queries.CanView(ctx, query, h.viewer(username))
+	if err != nil {
+		log.Printf("error checking visibility of query %s %v", query.Name, err)
+		return false
+	}
+	return ok
+}
+
+// Helper to check if a user can see the results of a query by name, in any environment if envid is 0
+func (h *HandlersAdmin) canViewName(ctx context.Context, name string, envid uint, username string) bool {
+	ok, err := h.Queries.CanViewName(ctx, name, envid, h.viewer(username))
+	if err != nil {
+		log.Printf("error checking visibility of query %s %v", name, err)
+		return false
+	}
+	return ok
+}
+
+// Helper to list queries or carves by target as they are listed to a user
+func (h *HandlersAdmin) listQueries(ctx context.Context, target, qtype string, envid uint, username string) ([]queries.DistributedQuery, error) {
+	return h.Queries.ListCtx(ctx, target, qtype, envid, h.viewer(username), h.Settings.RestrictedListing())
+}
+
+// Helper to serve the page of a query with results the user can not see, admins can break the glass from it
+func (h *HandlersAdmin) serveLockedQuery(w http.ResponseWriter, env environments.TLSEnvironment, ctx sessions.ContextValue, query queries.DistributedQuery) {
+	tempateFiles := h.NewTemplateFiles(h.TemplatesFolder, "queries-locked.html").filepaths
+	t, err := template.New("queries-locked.html").Funcs(template.FuncMap{"pastFutureTimes": utils.PastFutureTimes}).ParseFiles(tempateFiles...)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting table template: %v", err)
+		return
+	}
+	envAll, err := h.Envs.All()
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environments %v", err)
+		return
+	}
+	platforms, err := h.Nodes.GetAllPlatforms()
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting platforms: %v", err)
+		return
+	}
+	templateData := QueryLockedTemplateData{
+		Title:         "Restricted " + query.Name,
+		EnvUUID:       env.UUID,
+		Metadata:      h.EnvTemplateMetadata(ctx, h.ServiceVersion, env.UUID),
+		LeftMetadata:  AsideLeftMetadata{EnvUUID: env.UUID, Query: query.Type != queries.CarveQueryType, Carve: query.Type == queries.CarveQueryType},
+		Environments:  h.allowedEnvironments(ctx[sessions.CtxUser], envAll),
+		Platforms:     platforms,
+		Query:         queries.Locked(query),
+		CanBreakGlass: h.Users.CheckPermissions(ctx[sessions.CtxUser], users.AdminLevel, env.UUID),
+	}
+	w.WriteHeader(http.StatusForbidden)
+	if err := t.Execute(w, templateData); err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("template error %v", err)
+		return
+	}
+	h.Inc(metricAdminOK)
+}
+
+// QueryVisibilityPOSTHandler for POST requests to change who can see the results of a query,
+// only the creator of the query and admins can change it
+func (h *HandlersAdmin) QueryVisibilityPOSTHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), true)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		log.Println("environment is missing")
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Get environment
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		log.Printf("error getting environment %s - %v", envVar, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Check permissions for query
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.QueryLevel, env.UUID) {
+		adminErrorResponse(w, fmt.Sprintf("%s has insuficient permissions", ctx[sessions.CtxUser]), http.StatusForbidden, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	var v QueryVisibilityRequest
+	if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+		adminErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Check CSRF Token
+	if !sessions.CheckCSRFToken(ctx[sessions.CtxCSRF], v.CSRFToken) {
+		adminErrorResponse(w, "invalid CSRF token", http.StatusInternalServerError, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	query, err := h.Queries.GetCtx(r.Context(), v.Name, env.ID)
+	if err != nil || query.ID == 0 {
+		adminErrorResponse(w, "error getting query", http.StatusNotFound, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	if query.Creator != ctx[sessions.CtxUser] && !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.AdminLevel, env.UUID) {
+		adminErrorResponse(w, fmt.Sprintf("%s can not change the visibility of query %s", ctx[sessions.CtxUser], query.Name), http.StatusForbidden, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	before, err := h.Queries.SetVisibility(query.Name, env.ID, v.Visibility, v.Team)
+	if err != nil {
+		adminErrorResponse(w, "error setting query visibility", http.StatusBadRequest, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	after := queries.DistributedQuery{Visibility: v.Visibility, VisibilityTeam: v.Team}
+	h.recordActivity(env, environments.ActivityQueries, ctx[sessions.CtxUser], "visibility of query "+query.Name+" changed", before.VisibilitySummary(), after.VisibilitySummary())
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Query visibility response sent")
+	}
+	adminOKResponse(w, "query visibility saved successfully")
+	h.Inc(metricAdminOK)
+}
+
+// QueryBreakGlassPOSTHandler for POST requests of admins to see the results of a restricted query for a while,
+// with a reason that is recorded in the activity of the environment
+func (h *HandlersAdmin) QueryBreakGlassPOSTHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), true)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		log.Println("environment is missing")
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Get environment
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		log.Printf("error getting environment %s - %v", envVar, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Check permissions, only admins can break the glass
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.AdminLevel, env.UUID) {
+		adminErrorResponse(w, fmt.Sprintf("%s has insuficient permissions", ctx[sessions.CtxUser]), http.StatusForbidden, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	var b QueryBreakGlassRequest
+	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+		adminErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Check CSRF Token
+	if !sessions.CheckCSRFToken(ctx[sessions.CtxCSRF], b.CSRFToken) {
+		adminErrorResponse(w, "invalid CSRF token", http.StatusInternalServerError, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	query, err := h.Queries.GetCtx(r.Context(), b.Name, env.ID)
+	if err != nil || query.ID == 0 {
+		adminErrorResponse(w, "error getting query", http.StatusNotFound, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	if query.VisibleTo(h.viewer(ctx[sessions.CtxUser])) {
+		adminErrorResponse(w, "query results are already visible", http.StatusBadRequest, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	grant, err := h.Queries.BreakGlass(query, ctx[sessions.CtxUser], b.Reason)
+	if err != nil {
+		adminErrorResponse(w, "error breaking the glass", http.StatusBadRequest, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	h.recordActivity(env, environments.ActivityQueries, ctx[sessions.CtxUser], fmt.Sprintf("break glass to see results of query %s (%s) until %s: %s", query.Name, query.VisibilitySummary(), grant.ExpiresAt.Format("2006-01-02 15:04:05"), grant.Reason), "", "")
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Query break glass response sent")
+	}
+	adminOKResponse(w, "access to query results granted")
+	h.Inc(metricAdminOK)
+}
+
+// UserTeamsPOSTHandler for POST requests to add users to teams and remove them
+func (h *HandlersAdmin) UserTeamsPOSTHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), true)
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Check permissions
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.AdminLevel, users.NoEnvironment) {
+		adminErrorResponse(w, fmt.Sprintf("%s has insuficient permissions", ctx[sessions.CtxUser]), http.StatusForbidden, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	var t UserTeamRequest
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		adminErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Check CSRF Token
+	if !sessions.CheckCSRFToken(ctx[sessions.CtxCSRF], t.CSRFToken) {
+		adminErrorResponse(w, "invalid CSRF token", http.StatusInternalServerError, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	switch t.Action {
+	case "add":
+		if err := h.Users.AddTeamMember(t.Team, t.Username, ctx[sessions.CtxUser]); err != nil {
+			adminErrorResponse(w, "error adding to team", http.StatusBadRequest, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		log.Printf("user %s added to team %s by %s", t.Username, t.Team, ctx[sessions.CtxUser])
+		adminOKResponse(w, "user added to team successfully")
+	case "remove":
+		if err := h.Users.RemoveTeamMember(t.Team, t.Username); err != nil {
+			adminErrorResponse(w, "error removing from team", http.StatusBadRequest, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		log.Printf("user %s removed from team %s by %s", t.Username, t.Team, ctx[sessions.CtxUser])
+		adminOKResponse(w, "user removed from team successfully")
+	default:
+		adminErrorResponse(w, "invalid action", http.StatusBadRequest, fmt.Errorf("action %s", t.Action))
+		h.Inc(metricAdminErr)
+		return
+	}
+	h.Inc(metricAdminOK)
+}
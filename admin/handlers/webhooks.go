@@ -0,0 +1,297 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/admin/sessions"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+	"github.com/jmpsec/osctrl/webhooks"
+)
+
+// Number of deliveries of an endpoint shown
+const webhookDeliveriesShown = 100
+
+// WebhooksGETHandler for GET requests for /webhooks/{environment}, with the deliveries of an endpoint
+// or the attempts for an entity when requested
+func (h *HandlersAdmin) WebhooksGETHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), false)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["environment"]
+	if !ok {
+		h.Inc(metricAdminErr)
+		log.Println("error getting environment")
+		return
+	}
+	// Get environment
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environment: %v", err)
+		return
+	}
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Check permissions, endpoints may have credentials in their URLs
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.AdminLevel, env.UUID) {
+		log.Printf("%s has insuficient permissions", ctx[sessions.CtxUser])
+		h.Inc(metricAdminErr)
+		return
+	}
+	endpoints, err := h.Webhooks.Endpoints(env.ID)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting webhook endpoints: %v", err)
+		return
+	}
+	failed, err := h.Webhooks.DeadLetters(env.ID)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting failed webhook deliveries: %v", err)
+		return
+	}
+	// Deliveries of the selected endpoint, only the failed ones by default
+	var selected webhooks.WebhookEndpoint
+	var deliveries []webhooks.WebhookDelivery
+	state := r.URL.Query().Get("state")
+	if name := r.URL.Query().Get("endpoint"); name != "" {
+		if selected, err = h.Webhooks.GetEndpoint(env.ID, name); err != nil {
+			h.Inc(metricAdminErr)
+			log.Printf("error getting webhook endpoint %s: %v", name, err)
+			return
+		}
+		if state == "" {
+			state = webhooks.StateFailed
+		} else if state == "all" {
+			state = ""
+		}
+		if deliveries, err = h.Webhooks.Deliveries(selected.ID, state, webhookDeliveriesShown); err != nil {
+			h.Inc(metricAdminErr)
+			log.Printf("error getting webhook deliveries: %v", err)
+			return
+		}
+	}
+	// Attempts for the requested entity
+	entityType := r.URL.Query().Get("entity_type")
+	entityID := r.URL.Query().Get("entity_id")
+	var attempts []webhooks.WebhookAttempt
+	if entityType != "" && entityID != "" {
+		if attempts, err = h.Webhooks.EntityAttempts(env.ID, entityType, entityID); err != nil {
+			h.Inc(metricAdminErr)
+			log.Printf("error getting webhook attempts: %v", err)
+			return
+		}
+	}
+	// Custom functions to handle formatting
+	funcMap := template.FuncMap{
+		"pastFutureTimes": utils.PastFutureTimes,
+		"endpointEvents":  webhooks.EndpointEvents,
+	}
+	// Prepare template
+	tempateFiles := h.NewTemplateFiles(h.TemplatesFolder, "webhooks.html").filepaths
+	t, err := template.New("webhooks.html").Funcs(funcMap).ParseFiles(tempateFiles...)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting webhooks template: %v", err)
+		return
+	}
+	// Get stats for all environments
+	envAll, err := h.Envs.AllCtx(r.Context())
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environments %v", err)
+		return
+	}
+	// Get stats for all platforms
+	platforms, err := h.Nodes.GetAllPlatforms()
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting platforms: %v", err)
+		return
+	}
+	// Prepare template data
+	templateData := WebhooksTemplateData{
+		Title:        env.Name + " Webhooks",
+		EnvName:      env.Name,
+		EnvUUID:      env.UUID,
+		Endpoints:    endpoints,
+		Failed:       failed,
+		Events:       webhooks.Events,
+		Selected:     selected,
+		State:        state,
+		Deliveries:   deliveries,
+		EntityType:   entityType,
+		EntityID:     entityID,
+		Attempts:     attempts,
+		Now:          time.Now(),
+		Metadata:     h.EnvTemplateMetadata(ctx, h.ServiceVersion, env.UUID),
+		LeftMetadata: AsideLeftMetadata{EnvUUID: env.UUID},
+		Environments: h.allowedEnvironments(ctx[sessions.CtxUser], envAll),
+		Platforms:    platforms,
+	}
+	if err := t.Execute(w, templateData); err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("template error %v", err)
+		return
+	}
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Webhooks template served")
+	}
+	h.Inc(metricAdminOK)
+}
+
+// WebhooksPOSTHandler for POST requests to manage the webhook endpoints of an environment and their deliveries
+func (h *HandlersAdmin) WebhooksPOSTHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricAdminReq)
+	utils.DebugHTTPDump(r, h.Settings.DebugHTTP(settings.ServiceAdmin), true)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["environment"]
+	if !ok {
+		h.Inc(metricAdminErr)
+		log.Println("error getting environment")
+		return
+	}
+	// Get environment
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		h.Inc(metricAdminErr)
+		log.Printf("error getting environment: %v", err)
+		return
+	}
+	var f WebhooksRequest
+	// Get context data
+	ctx := r.Context().Value(sessions.ContextKey("session")).(sessions.ContextValue)
+	// Parse request JSON body
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Decoding POST body")
+	}
+	if err := json.NewDecoder(r.Body).Decode(&f); err != nil {
+		adminErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Check permissions
+	if !h.Users.CheckPermissions(ctx[sessions.CtxUser], users.AdminLevel, env.UUID) {
+		adminErrorResponse(w, fmt.Sprintf("%s has insuficient permissions", ctx[sessions.CtxUser]), http.StatusForbidden, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Check CSRF Token
+	if !sessions.CheckCSRFToken(ctx[sessions.CtxCSRF], f.CSRFToken) {
+		adminErrorResponse(w, "invalid CSRF token", http.StatusInternalServerError, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	before, err := h.Webhooks.Endpoints(env.ID)
+	if err != nil {
+		adminErrorResponse(w, "error getting endpoints", http.StatusInternalServerError, err)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Helper to record changes of endpoints
+	record := func(summary string) {
+		after, err := h.Webhooks.Endpoints(env.ID)
+		if err != nil {
+			log.Printf("error getting webhook endpoints %v", err)
+		}
+		h.recordActivity(env, environments.ActivityWebhooks, ctx[sessions.CtxUser], summary, webhooks.SerializeEndpoints(before), webhooks.SerializeEndpoints(after))
+	}
+	switch f.Action {
+	case "create":
+		e, err := h.Webhooks.CreateEndpoint(env.ID, webhooks.WebhookEndpoint{
+			Name:    f.Name,
+			URL:     f.URL,
+			Events:  strings.Join(f.Events, ","),
+			Creator: ctx[sessions.CtxUser],
+		})
+		if err != nil {
+			adminErrorResponse(w, "error creating endpoint", http.StatusBadRequest, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		record("webhook endpoint " + e.Name + " created")
+		utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, WebhookSecretResponse{Message: "endpoint created successfully", Secret: e.Secret})
+	case "rotate":
+		e, err := h.Webhooks.RotateSecret(env.ID, f.Name)
+		if err != nil {
+			adminErrorResponse(w, "error rotating secret", http.StatusInternalServerError, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		h.recordActivity(env, environments.ActivityWebhooks, ctx[sessions.CtxUser], "secret of webhook endpoint "+e.Name+" rotated", "", "")
+		utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, WebhookSecretResponse{Message: "secret rotated successfully", Secret: e.Secret})
+	case "enable", "disable":
+		e, err := h.Webhooks.SetEnabled(env.ID, f.Name, f.Action == "enable")
+		if err != nil {
+			adminErrorResponse(w, "error changing endpoint", http.StatusInternalServerError, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		record("webhook endpoint " + e.Name + " " + f.Action + "d")
+		adminOKResponse(w, "endpoint "+f.Action+"d successfully")
+	case "delete":
+		if err := h.Webhooks.DeleteEndpoint(env.ID, f.Name); err != nil {
+			adminErrorResponse(w, "error deleting endpoint", http.StatusInternalServerError, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		record("webhook endpoint " + f.Name + " deleted")
+		adminOKResponse(w, "endpoint deleted successfully")
+	case "test":
+		d, err := h.Webhooks.TestFire(env.ID, f.Name, ctx[sessions.CtxUser])
+		if err != nil {
+			adminErrorResponse(w, "error sending test event", http.StatusInternalServerError, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		if d.State != webhooks.StateDelivered {
+			adminErrorResponse(w, fmt.Sprintf("test event not delivered (%d): %s", d.StatusCode, d.Error), http.StatusBadGateway, nil)
+			h.Inc(metricAdminErr)
+			return
+		}
+		adminOKResponse(w, fmt.Sprintf("test event delivered (%d)", d.StatusCode))
+	case "redeliver", "redeliver_failed":
+		e, err := h.Webhooks.GetEndpoint(env.ID, f.Name)
+		if err != nil {
+			adminErrorResponse(w, "error getting endpoint", http.StatusNotFound, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		summary := fmt.Sprintf("delivery %d of webhook endpoint %s queued again", f.DeliveryID, e.Name)
+		if f.Action == "redeliver" {
+			_, err = h.Webhooks.Redeliver(e.ID, f.DeliveryID)
+		} else {
+			var queued int64
+			queued, err = h.Webhooks.RedeliverFailed(e.ID)
+			summary = fmt.Sprintf("%d failed deliveries of webhook endpoint %s queued again", queued, e.Name)
+		}
+		if err != nil {
+			adminErrorResponse(w, "error redelivering", http.StatusBadRequest, err)
+			h.Inc(metricAdminErr)
+			return
+		}
+		h.recordActivity(env, environments.ActivityWebhooks, ctx[sessions.CtxUser], summary, "", "")
+		adminOKResponse(w, summary)
+	default:
+		adminErrorResponse(w, "invalid action", http.StatusBadRequest, nil)
+		h.Inc(metricAdminErr)
+		return
+	}
+	// Serialize and send response
+	if h.Settings.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Webhooks response sent")
+	}
+	h.Inc(metricAdminOK)
+}
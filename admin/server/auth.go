@@ -1,4 +1,4 @@
-package main
+package server
 
 import (
 	"context"
@@ -30,7 +30,7 @@ func handlerAuthCheck(h http.Handler) http.Handler {
 			// Check if user is already authenticated
 			authenticated, session := sessionsmgr.CheckAuth(r)
 			if !authenticated {
-				http.Redirect(w, r, "/login", http.StatusFound)
+				http.Redirect(w, r, adminConfig.URLPrefix+loginPath, http.StatusFound)
 				return
 			}
 			// Set middleware values
@@ -67,19 +67,19 @@ func handlerAuthCheck(h http.Handler) http.Handler {
 				// Create user if it does not exist
 				if !adminUsers.Exists(jwtdata.Username) {
 					log.Printf("user not found: %s", jwtdata.Username)
-					http.Redirect(w, r, forbiddenPath, http.StatusFound)
+					http.Redirect(w, r, adminConfig.URLPrefix+forbiddenPath, http.StatusFound)
 					return
 				}
 				u, err := adminUsers.Get(jwtdata.Username)
 				if err != nil {
 					log.Printf("error getting user %s: %v", jwtdata.Username, err)
-					http.Redirect(w, r, forbiddenPath, http.StatusFound)
+					http.Redirect(w, r, adminConfig.URLPrefix+forbiddenPath, http.StatusFound)
 					return
 				}
 				access, err := adminUsers.GetEnvAccess(u.Username, u.DefaultEnv)
 				if err != nil {
 					log.Printf("error getting access for %s: %v", jwtdata.Username, err)
-					http.Redirect(w, r, forbiddenPath, http.StatusFound)
+					http.Redirect(w, r, adminConfig.URLPrefix+forbiddenPath, http.StatusFound)
 					return
 				}
 				// Create new session
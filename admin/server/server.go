@@ -0,0 +1,1523 @@
+package server
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/crewjam/saml/samlsp"
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/admin/handlers"
+	"github.com/jmpsec/osctrl/admin/sessions"
+	"github.com/jmpsec/osctrl/backend"
+	"github.com/jmpsec/osctrl/cache"
+	"github.com/jmpsec/osctrl/carves"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/logging"
+	"github.com/jmpsec/osctrl/metrics"
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/jmpsec/osctrl/queries"
+	"github.com/jmpsec/osctrl/reports"
+	"github.com/jmpsec/osctrl/responses"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/tags"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+	"github.com/jmpsec/osctrl/version"
+	"github.com/jmpsec/osctrl/webhooks"
+	"github.com/spf13/viper"
+	"github.com/urfave/cli/v2"
+	"osctrl/service"
+)
+
+// Constants for the service
+const (
+	// Project name
+	projectName string = "osctrl"
+	// Service name
+	serviceName string = projectName + "-" + settings.ServiceAdmin
+	// Service version
+	serviceVersion string = version.OsctrlVersion
+	// Service description
+	serviceDescription string = "Admin service for osctrl"
+	// Application description
+	appDescription string = serviceDescription + ", a fast and efficient osquery management"
+)
+
+// Paths
+const (
+	// Default endpoint to handle HTTP health
+	healthPath string = "/health"
+	// Default endpoint to handle the version and build of the service
+	versionPath string = "/version"
+	// Default endpoint to handle Login
+	loginPath string = "/login"
+	// Default endpoint for the first-run setup
+	setupPath string = "/setup"
+	// Default endpoint to handle HTTP(500) errors
+	errorPath string = "/error"
+	// Default endpoint to handle Forbidden(403) errors
+	forbiddenPath string = "/forbidden"
+	// Default endpoint for favicon
+	faviconPath string = "/favicon.ico"
+)
+
+// Configuration
+const (
+	// Default SAML configuration file
+	defSAMLConfigurationFile string = "config/saml.json"
+	// Default JWT configuration file
+	defJWTConfigurationFile string = "config/jwt.json"
+	// Default service configuration file
+	defConfigurationFile string = "config/" + settings.ServiceAdmin + ".json"
+	// Default DB configuration file
+	defDBConfigurationFile string = "config/db.json"
+	// Default redis configuration file
+	defRedisConfigurationFile string = "config/redis.json"
+	// Default Logger configuration file
+	defLoggerConfigurationFile string = "config/logger.json"
+	// Default TLS certificate file
+	defTLSCertificateFile string = "config/tls.crt"
+	// Default TLS private key file
+	defTLSKeyFile string = "config/tls.key"
+	// Default carver configuration file
+	defCarverConfigurationFile string = "config/carver.json"
+	// Default SMTP configuration file
+	defSMTPConfigurationFile string = "config/smtp.json"
+)
+
+// Random
+const (
+	// Static files folder
+	defStaticFilesFolder string = "./static"
+	// Default templates folder
+	defTemplatesFolder string = "./tmpl_admin"
+	// Default carved files folder
+	defCarvedFolder string = "./carved_files/"
+	// Default refreshing interval in seconds
+	defaultRefresh int = 300
+	// Default hours to classify nodes as inactive
+	defaultInactive int = -72
+	// Default days for decommissioned nodes to stop contacting
+	defaultDecommissionGrace int = 7
+)
+
+// osquery
+const (
+	// osquery version to display tables
+	defOsqueryTablesVersion = version.OsqueryVersion
+	// JSON file with osquery tables data
+	defOsqueryTablesFile string = "data/" + defOsqueryTablesVersion + ".json"
+)
+
+var (
+	// Wait for pending requests when the service shuts down
+	shutdownTimeout = 30 * time.Second
+)
+
+// Global general variables
+var (
+	err            error
+	adminConfig    types.JSONConfigurationAdmin
+	s3LogConfig    types.S3Configuration
+	s3CarverConfig types.S3Configuration
+	dbConfig       backend.JSONConfigurationDB
+	redisConfig    cache.JSONConfigurationRedis
+	db             *backend.DBManager
+	redis          *cache.RedisManager
+	settingsmgr    *settings.Settings
+	nodesmgr       *nodes.NodeManager
+	queriesmgr     *queries.Queries
+	carvesmgr      *carves.Carves
+	responsesmgr   *responses.ResponseManager
+	webhooksmgr    *webhooks.WebhookManager
+	sessionsmgr    *sessions.SessionManager
+	envs           *environments.Environment
+	adminUsers     *users.UserManager
+	tagsmgr        *tags.TagManager
+	carvers3       *carves.CarverS3
+	reportsmgr     *reports.ReportManager
+	deadletters    *logging.DeadLetters
+	smtpConfig     types.SMTPConfiguration
+	app            *cli.App
+	flags          []cli.Flag
+	// FIXME this is nasty and should not be a global but here we are
+	osqueryTables []types.OsqueryTable
+	adminMetrics  *metrics.Metrics
+	handlersAdmin *handlers.HandlersAdmin
+)
+
+// Variables for flags
+var (
+	configFlag           bool
+	dbFlag               bool
+	redisFlag            bool
+	serviceConfigFile    string
+	dbConfigFile         string
+	redisConfigFile      string
+	tlsServer            bool
+	tlsCertFile          string
+	tlsKeyFile           string
+	tlsProfile           string
+	tlsMinVersion        string
+	tlsMaxVersion        string
+	tlsCiphers           string
+	tlsCurves            string
+	tlsNoTickets         bool
+	samlConfigFile       string
+	jwtFlag              bool
+	jwtConfigFile        string
+	osqueryTablesFile    string
+	osqueryTablesVersion string
+	loggerFile           string
+	staticFilesFolder    string
+	staticOffline        bool
+	carvedFilesFolder    string
+	templatesFolder      string
+	carverConfigFile     string
+	reportsTemplateFile  string
+	reportsPDFConverter  string
+	reportsStorage       string
+	reportsInterval      int
+	smtpFlag             bool
+	smtpConfigFile       string
+	compressionDisabled  bool
+	compressionMinSize   int
+	setupToken           string
+	packCredentialsDir   string
+	statusTokenSecret    string
+)
+
+// SAML variables
+var (
+	samlMiddleware *samlsp.Middleware
+	samlConfig     JSONConfigurationSAML
+	samlData       samlThings
+)
+
+// JWT variables
+var (
+	jwtConfig types.JSONConfigurationJWT
+)
+
+// Valid values for auth in configuration
+var validAuth = map[string]bool{
+	settings.AuthDB:   true,
+	settings.AuthSAML: true,
+	settings.AuthJSON: true,
+}
+
+// Valid values for carver in configuration
+var validCarver = map[string]bool{
+	settings.CarverDB:    true,
+	settings.CarverLocal: true,
+	settings.CarverS3:    true,
+}
+
+// Function to load the configuration file
+func loadConfiguration(file, service string) (types.JSONConfigurationAdmin, error) {
+	var cfg types.JSONConfigurationAdmin
+	log.Printf("Loading %s", file)
+	// Load file and read config
+	viper.SetConfigFile(file)
+	if err := viper.ReadInConfig(); err != nil {
+		return cfg, err
+	}
+	// Admin values
+	adminRaw := viper.Sub(service)
+	if adminRaw == nil {
+		return cfg, fmt.Errorf("JSON key %s not found in %s", service, file)
+	}
+	if err := adminRaw.Unmarshal(&cfg); err != nil {
+		return cfg, err
+	}
+	// Check if values are valid
+	if !validAuth[cfg.Auth] {
+		return cfg, fmt.Errorf("Invalid auth method")
+	}
+	if !validCarver[cfg.Carver] {
+		return cfg, fmt.Errorf("Invalid carver method")
+	}
+	// No errors!
+	return cfg, nil
+}
+
+// Initialization code
+func init() {
+	// Initialize CLI flags
+	flags = []cli.Flag{
+		&cli.BoolFlag{
+			Name:        "config",
+			Aliases:     []string{"c"},
+			Value:       false,
+			Usage:       "Provide service configuration via JSON file",
+			EnvVars:     []string{"SERVICE_CONFIG"},
+			Destination: &configFlag,
+		},
+		&cli.StringFlag{
+			Name:        "config-file",
+			Aliases:     []string{"C"},
+			Value:       defConfigurationFile,
+			Usage:       "Load service configuration from `FILE`",
+			EnvVars:     []string{"SERVICE_CONFIG_FILE"},
+			Destination: &serviceConfigFile,
+		},
+		&cli.StringFlag{
+			Name:        "listener",
+			Aliases:     []string{"l"},
+			Value:       "0.0.0.0",
+			Usage:       "Listener for the service",
+			EnvVars:     []string{"SERVICE_LISTENER"},
+			Destination: &adminConfig.Listener,
+		},
+		&cli.StringFlag{
+			Name:        "port",
+			Aliases:     []string{"p"},
+			Value:       "9001",
+			Usage:       "TCP port for the service",
+			EnvVars:     []string{"SERVICE_PORT"},
+			Destination: &adminConfig.Port,
+		},
+		&cli.StringFlag{
+			Name:        "auth",
+			Aliases:     []string{"A"},
+			Value:       settings.AuthDB,
+			Usage:       "Authentication mechanism for the service",
+			EnvVars:     []string{"SERVICE_AUTH"},
+			Destination: &adminConfig.Auth,
+		},
+		&cli.StringFlag{
+			Name:        "host",
+			Aliases:     []string{"H"},
+			Value:       "0.0.0.0",
+			Usage:       "Exposed hostname the service uses",
+			EnvVars:     []string{"SERVICE_HOST"},
+			Destination: &adminConfig.Host,
+		},
+		&cli.StringFlag{
+			Name:        "session-key",
+			Value:       "",
+			Usage:       "Session key to generate cookies from it",
+			EnvVars:     []string{"SESSION_KEY"},
+			Destination: &adminConfig.SessionKey,
+		},
+		&cli.StringFlag{
+			Name:        "url-prefix",
+			Value:       "",
+			Usage:       "URL path prefix to serve the service under, when it runs behind a reverse proxy",
+			EnvVars:     []string{"SERVICE_URL_PREFIX"},
+			Destination: &adminConfig.URLPrefix,
+		},
+		&cli.StringFlag{
+			Name:        "setup-token",
+			Value:       "",
+			Usage:       "Token for the first-run setup, a random one is generated and logged if not provided",
+			EnvVars:     []string{"SETUP_TOKEN"},
+			Destination: &setupToken,
+		},
+		&cli.StringFlag{
+			Name:        "pack-credentials-dir",
+			Value:       environments.DefaultPackCredentialsDir,
+			Usage:       "Directory of the files with credentials of pack sources, sources can only use files in this directory",
+			EnvVars:     []string{"PACK_CREDENTIALS_DIR"},
+			Destination: &packCredentialsDir,
+		},
+		&cli.StringFlag{
+			Name:        "status-token-secret",
+			Value:       "",
+			Usage:       "Secret to sign node status tokens, the same for osctrl-admin and osctrl-api. Status tokens need it",
+			EnvVars:     []string{"STATUS_TOKEN_SECRET"},
+			Destination: &statusTokenSecret,
+		},
+		&cli.StringFlag{
+			Name:        "logging",
+			Aliases:     []string{"L"},
+			Value:       settings.LoggingDB,
+			Usage:       "Logging mechanism to handle logs from nodes",
+			EnvVars:     []string{"SERVICE_LOGGER"},
+			Destination: &adminConfig.Logger,
+		},
+		&cli.BoolFlag{
+			Name:        "redis",
+			Aliases:     []string{"r"},
+			Value:       false,
+			Usage:       "Provide redis configuration via JSON file",
+			EnvVars:     []string{"REDIS_CONFIG"},
+			Destination: &redisFlag,
+		},
+		&cli.StringFlag{
+			Name:        "redis-file",
+			Aliases:     []string{"R"},
+			Value:       defRedisConfigurationFile,
+			Usage:       "Load redis configuration from `FILE`",
+			EnvVars:     []string{"REDIS_CONFIG_FILE"},
+			Destination: &redisConfigFile,
+		},
+		&cli.StringFlag{
+			Name:        "redis-connection-string",
+			Value:       "",
+			Usage:       "Redis connection string, must include schema (<redis|rediss|unix>://<user>:<pass>@<host>:<port>/<db>?<options>",
+			EnvVars:     []string{"REDIS_CONNECTION_STRING"},
+			Destination: &redisConfig.ConnectionString,
+		},
+		&cli.StringFlag{
+			Name:        "redis-host",
+			Value:       "127.0.0.1",
+			Usage:       "Redis host to be connected to",
+			EnvVars:     []string{"REDIS_HOST"},
+			Destination: &redisConfig.Host,
+		},
+		&cli.StringFlag{
+			Name:        "redis-port",
+			Value:       "6379",
+			Usage:       "Redis port to be connected to",
+			EnvVars:     []string{"REDIS_PORT"},
+			Destination: &redisConfig.Port,
+		},
+		&cli.StringFlag{
+			Name:        "redis-pass",
+			Value:       "",
+			Usage:       "Password to be used for redis",
+			EnvVars:     []string{"REDIS_PASS"},
+			Destination: &redisConfig.Password,
+		},
+		&cli.IntFlag{
+			Name:        "redis-db",
+			Value:       0,
+			Usage:       "Redis database to be selected after connecting",
+			EnvVars:     []string{"REDIS_DB"},
+			Destination: &redisConfig.DB,
+		},
+		&cli.IntFlag{
+			Name:        "redis-status-exp",
+			Value:       cache.StatusExpiration,
+			Usage:       "Redis expiration in hours for status logs",
+			EnvVars:     []string{"REDIS_STATUS_EXP"},
+			Destination: &redisConfig.StatusExpirationHours,
+		},
+		&cli.IntFlag{
+			Name:        "redis-result-exp",
+			Value:       cache.ResultExpiration,
+			Usage:       "Redis expiration in hours for result logs",
+			EnvVars:     []string{"REDIS_RESULT_EXP"},
+			Destination: &redisConfig.ResultExpirationHours,
+		},
+		&cli.IntFlag{
+			Name:        "redis-query-exp",
+			Value:       cache.QueryExpiration,
+			Usage:       "Redis expiration in hours for query logs",
+			EnvVars:     []string{"REDIS_QUERY_EXP"},
+			Destination: &redisConfig.QueryExpirationHours,
+		},
+		&cli.BoolFlag{
+			Name:        "db",
+			Aliases:     []string{"d"},
+			Value:       false,
+			Usage:       "Provide DB configuration via JSON file",
+			EnvVars:     []string{"DB_CONFIG"},
+			Destination: &dbFlag,
+		},
+		&cli.StringFlag{
+			Name:        "db-file",
+			Aliases:     []string{"D"},
+			Value:       defDBConfigurationFile,
+			Usage:       "Load DB configuration from `FILE`",
+			EnvVars:     []string{"DB_CONFIG_FILE"},
+			Destination: &dbConfigFile,
+		},
+		&cli.StringFlag{
+			Name:        "db-host",
+			Value:       "127.0.0.1",
+			Usage:       "Backend host to be connected to",
+			EnvVars:     []string{"DB_HOST"},
+			Destination: &dbConfig.Host,
+		},
+		&cli.StringFlag{
+			Name:        "db-port",
+			Value:       "5432",
+			Usage:       "Backend port to be connected to",
+			EnvVars:     []string{"DB_PORT"},
+			Destination: &dbConfig.Port,
+		},
+		&cli.StringFlag{
+			Name:        "db-name",
+			Value:       "osctrl",
+			Usage:       "Database name to be used in the backend",
+			EnvVars:     []string{"DB_NAME"},
+			Destination: &dbConfig.Name,
+		},
+		&cli.StringFlag{
+			Name:        "db-user",
+			Value:       "postgres",
+			Usage:       "Username to be used for the backend",
+			EnvVars:     []string{"DB_USER"},
+			Destination: &dbConfig.Username,
+		},
+		&cli.StringFlag{
+			Name:        "db-pass",
+			Value:       "postgres",
+			Usage:       "Password to be used for the backend",
+			EnvVars:     []string{"DB_PASS"},
+			Destination: &dbConfig.Password,
+		},
+		&cli.IntFlag{
+			Name:        "db-max-idle-conns",
+			Value:       20,
+			Usage:       "Maximum number of connections in the idle connection pool",
+			EnvVars:     []string{"DB_MAX_IDLE_CONNS"},
+			Destination: &dbConfig.MaxIdleConns,
+		},
+		&cli.IntFlag{
+			Name:        "db-max-open-conns",
+			Value:       100,
+			Usage:       "Maximum number of open connections to the database",
+			EnvVars:     []string{"DB_MAX_OPEN_CONNS"},
+			Destination: &dbConfig.MaxOpenConns,
+		},
+		&cli.IntFlag{
+			Name:        "db-conn-max-lifetime",
+			Value:       30,
+			Usage:       "Maximum amount of time a connection may be reused",
+			EnvVars:     []string{"DB_CONN_MAX_LIFETIME"},
+			Destination: &dbConfig.ConnMaxLifetime,
+		},
+		&cli.IntFlag{
+			Name:        "db-conn-max-idle-time",
+			Value:       0,
+			Usage:       "Maximum amount of time in seconds a connection may be idle, 0 to keep idle connections",
+			EnvVars:     []string{"DB_CONN_MAX_IDLE_TIME"},
+			Destination: &dbConfig.ConnMaxIdleTime,
+		},
+		&cli.IntFlag{
+			Name:        "db-instances",
+			Value:       1,
+			Usage:       "Expected number of instances of this service, to check if their connections fit in the database",
+			EnvVars:     []string{"DB_INSTANCES"},
+			Destination: &dbConfig.Instances,
+		},
+		&cli.BoolFlag{
+			Name:        "tls",
+			Aliases:     []string{"t"},
+			Value:       false,
+			Usage:       "Enable TLS termination. It requires certificate and key",
+			EnvVars:     []string{"TLS_SERVER"},
+			Destination: &tlsServer,
+		},
+		&cli.StringFlag{
+			Name:        "cert",
+			Aliases:     []string{"T"},
+			Value:       defTLSCertificateFile,
+			Usage:       "TLS termination certificate from `FILE`",
+			EnvVars:     []string{"TLS_CERTIFICATE"},
+			Destination: &tlsCertFile,
+		},
+		&cli.StringFlag{
+			Name:        "key",
+			Aliases:     []string{"K"},
+			Value:       defTLSKeyFile,
+			Usage:       "TLS termination private key from `FILE`",
+			EnvVars:     []string{"TLS_KEY"},
+			Destination: &tlsKeyFile,
+		},
+		&cli.StringFlag{
+			Name:        "tls-profile",
+			Value:       utils.DefaultTLSProfile,
+			Usage:       "TLS termination profile (legacy, modern, intermediate or fips)",
+			EnvVars:     []string{"TLS_PROFILE"},
+			Destination: &tlsProfile,
+		},
+		&cli.StringFlag{
+			Name:        "tls-min-version",
+			Value:       "",
+			Usage:       "Override the minimum TLS version of the profile (1.0, 1.1, 1.2 or 1.3)",
+			EnvVars:     []string{"TLS_MIN_VERSION"},
+			Destination: &tlsMinVersion,
+		},
+		&cli.StringFlag{
+			Name:        "tls-max-version",
+			Value:       "",
+			Usage:       "Override the maximum TLS version of the profile (1.0, 1.1, 1.2 or 1.3)",
+			EnvVars:     []string{"TLS_MAX_VERSION"},
+			Destination: &tlsMaxVersion,
+		},
+		&cli.StringFlag{
+			Name:        "tls-ciphers",
+			Value:       "",
+			Usage:       "Override the cipher suites of the profile, as comma separated list of Go names",
+			EnvVars:     []string{"TLS_CIPHERS"},
+			Destination: &tlsCiphers,
+		},
+		&cli.StringFlag{
+			Name:        "tls-curves",
+			Value:       "",
+			Usage:       "Override the curves of the profile, as comma separated list (X25519, P256, P384, P521)",
+			EnvVars:     []string{"TLS_CURVES"},
+			Destination: &tlsCurves,
+		},
+		&cli.BoolFlag{
+			Name:        "tls-disable-session-tickets",
+			Value:       false,
+			Usage:       "Disable TLS session tickets",
+			EnvVars:     []string{"TLS_DISABLE_SESSION_TICKETS"},
+			Destination: &tlsNoTickets,
+		},
+		&cli.StringFlag{
+			Name:        "saml-file",
+			Value:       defSAMLConfigurationFile,
+			Usage:       "Load SAML configuration from `FILE`",
+			EnvVars:     []string{"SAML_CONFIG_FILE"},
+			Destination: &samlConfigFile,
+		},
+		&cli.BoolFlag{
+			Name:        "jwt",
+			Aliases:     []string{"j"},
+			Value:       false,
+			Usage:       "Provide JWT configuration via JSON file",
+			EnvVars:     []string{"JWT_CONFIG"},
+			Destination: &jwtFlag,
+		},
+		&cli.StringFlag{
+			Name:        "jwt-file",
+			Value:       defJWTConfigurationFile,
+			Usage:       "Load JWT configuration from `FILE`",
+			EnvVars:     []string{"JWT_CONFIG_FILE"},
+			Destination: &jwtConfigFile,
+		},
+		&cli.StringFlag{
+			Name:        "jwt-secret",
+			Usage:       "Password to be used for the backend",
+			EnvVars:     []string{"JWT_SECRET"},
+			Destination: &jwtConfig.JWTSecret,
+		},
+		&cli.IntFlag{
+			Name:        "jwt-expire",
+			Value:       3,
+			Usage:       "Maximum amount of hours for the tokens to expire",
+			EnvVars:     []string{"JWT_EXPIRE"},
+			Destination: &jwtConfig.HoursToExpire,
+		},
+		&cli.StringFlag{
+			Name:        "osquery-version",
+			Value:       defOsqueryTablesVersion,
+			Usage:       "Set osquery version as default to be used",
+			EnvVars:     []string{"OSQUERY_VERSION"},
+			Destination: &osqueryTablesVersion,
+		},
+		&cli.StringFlag{
+			Name:        "osquery-tables",
+			Value:       defOsqueryTablesFile,
+			Usage:       "Load osquery tables schema from `FILE`",
+			EnvVars:     []string{"OSQUERY_TABLES"},
+			Destination: &osqueryTablesFile,
+		},
+		&cli.StringFlag{
+			Name:        "logger-file",
+			Aliases:     []string{"F"},
+			Value:       defLoggerConfigurationFile,
+			Usage:       "Logger configuration to handle status/results logs from nodes",
+			EnvVars:     []string{"LOGGER_FILE"},
+			Destination: &loggerFile,
+		},
+		&cli.StringFlag{
+			Name:        "static",
+			Aliases:     []string{"s"},
+			Value:       defStaticFilesFolder,
+			Usage:       "Directory with all the static files needed for the osctrl-admin UI",
+			EnvVars:     []string{"STATIC_FILES"},
+			Destination: &staticFilesFolder,
+		},
+		&cli.BoolFlag{
+			Name:        "static-offline",
+			Aliases:     []string{"S"},
+			Value:       false,
+			Usage:       "Use offline static files (js and css). Default is online files.",
+			EnvVars:     []string{"STATIC_ONLINE"},
+			Destination: &staticOffline,
+		},
+		&cli.StringFlag{
+			Name:        "templates",
+			Value:       defTemplatesFolder,
+			Usage:       "Directory with all the templates needed for the osctrl-admin UI",
+			EnvVars:     []string{"STATIC_FILES"},
+			Destination: &templatesFolder,
+		},
+		&cli.StringFlag{
+			Name:        "carved",
+			Value:       defCarvedFolder,
+			Usage:       "Directory for all the received carved files from osquery",
+			EnvVars:     []string{"CARVED_FILES"},
+			Destination: &carvedFilesFolder,
+		},
+		&cli.StringFlag{
+			Name:        "carver-type",
+			Value:       settings.CarverDB,
+			Usage:       "Carver to be used to receive files extracted from nodes",
+			EnvVars:     []string{"CARVER_TYPE"},
+			Destination: &adminConfig.Carver,
+		},
+		&cli.StringFlag{
+			Name:        "carver-file",
+			Value:       defCarverConfigurationFile,
+			Usage:       "Carver configuration file to receive files extracted from nodes",
+			EnvVars:     []string{"CARVER_FILE"},
+			Destination: &carverConfigFile,
+		},
+		&cli.StringFlag{
+			Name:        "log-s3-bucket",
+			Value:       "",
+			Usage:       "S3 bucket to be used as configuration for logging",
+			EnvVars:     []string{"LOG_S3_BUCKET"},
+			Destination: &s3LogConfig.Bucket,
+		},
+		&cli.StringFlag{
+			Name:        "log-s3-region",
+			Value:       "",
+			Usage:       "S3 region to be used as configuration for logging",
+			EnvVars:     []string{"LOG_S3_REGION"},
+			Destination: &s3LogConfig.Region,
+		},
+		&cli.StringFlag{
+			Name:        "log-s3-key-id",
+			Value:       "",
+			Usage:       "S3 access key id to be used as configuration for logging",
+			EnvVars:     []string{"LOG_S3_KEY_ID"},
+			Destination: &s3LogConfig.AccessKey,
+		},
+		&cli.StringFlag{
+			Name:        "log-s3-secret",
+			Value:       "",
+			Usage:       "S3 access key secret to be used as configuration for logging",
+			EnvVars:     []string{"LOG_S3_SECRET"},
+			Destination: &s3LogConfig.SecretAccessKey,
+		},
+		&cli.StringFlag{
+			Name:        "carver-s3-bucket",
+			Value:       "",
+			Usage:       "S3 bucket to be used as configuration for carves",
+			EnvVars:     []string{"CARVER_S3_BUCKET"},
+			Destination: &s3CarverConfig.Bucket,
+		},
+		&cli.StringFlag{
+			Name:        "carver-s3-region",
+			Value:       "",
+			Usage:       "S3 region to be used as configuration for carves",
+			EnvVars:     []string{"CARVER_S3_REGION"},
+			Destination: &s3CarverConfig.Region,
+		},
+		&cli.StringFlag{
+			Name:        "carve-s3-key-id",
+			Value:       "",
+			Usage:       "S3 access key id to be used as configuration for carves",
+			EnvVars:     []string{"CARVER_S3_KEY_ID"},
+			Destination: &s3CarverConfig.AccessKey,
+		},
+		&cli.StringFlag{
+			Name:        "carve-s3-secret",
+			Value:       "",
+			Usage:       "S3 access key secret to be used as configuration for carves",
+			EnvVars:     []string{"CARVER_S3_SECRET"},
+			Destination: &s3CarverConfig.SecretAccessKey,
+		},
+		&cli.StringFlag{
+			Name:        "reports-template",
+			Value:       "",
+			Usage:       "Template file to render fleet reports, the default template is used if empty",
+			EnvVars:     []string{"REPORTS_TEMPLATE"},
+			Destination: &reportsTemplateFile,
+		},
+		&cli.StringFlag{
+			Name:        "reports-pdf",
+			Value:       "",
+			Usage:       "Command to convert reports to PDF, reading HTML from stdin and writing PDF to stdout (wkhtmltopdf --quiet - -)",
+			EnvVars:     []string{"REPORTS_PDF"},
+			Destination: &reportsPDFConverter,
+		},
+		&cli.StringFlag{
+			Name:        "reports-storage",
+			Value:       reports.StorageDB,
+			Usage:       "Storage for generated reports, db or s3 (using the S3 carver bucket)",
+			EnvVars:     []string{"REPORTS_STORAGE"},
+			Destination: &reportsStorage,
+		},
+		&cli.IntFlag{
+			Name:        "reports-interval",
+			Value:       int(reports.DefaultSchedulerInterval / time.Minute),
+			Usage:       "Interval in minutes to check for scheduled reports",
+			EnvVars:     []string{"REPORTS_INTERVAL"},
+			Destination: &reportsInterval,
+		},
+		&cli.BoolFlag{
+			Name:        "smtp",
+			Value:       false,
+			Usage:       "Send scheduled reports by email, using the SMTP configuration file",
+			EnvVars:     []string{"SMTP"},
+			Destination: &smtpFlag,
+		},
+		&cli.StringFlag{
+			Name:        "smtp-file",
+			Value:       defSMTPConfigurationFile,
+			Usage:       "Load SMTP configuration from `FILE`",
+			EnvVars:     []string{"SMTP_CONFIG_FILE"},
+			Destination: &smtpConfigFile,
+		},
+		&cli.BoolFlag{
+			Name:        "disable-compression",
+			Value:       false,
+			Usage:       "Disable gzip/deflate compression of responses",
+			EnvVars:     []string{"DISABLE_COMPRESSION"},
+			Destination: &compressionDisabled,
+		},
+		&cli.IntFlag{
+			Name:        "compression-min-size",
+			Value:       utils.DefaultCompressMinSize,
+			Usage:       "Minimum size in bytes for responses to be compressed",
+			EnvVars:     []string{"COMPRESSION_MIN_SIZE"},
+			Destination: &compressionMinSize,
+		},
+	}
+	// Logging format flags
+	log.SetFlags(log.Lshortfile)
+}
+
+// Go go!
+func osctrlAdminService(ctx context.Context, shared *service.Shared) {
+	adminConfig.URLPrefix = utils.NormalizeURLPrefix(adminConfig.URLPrefix)
+	// Managers created by the service are stopped by it, shared ones by the process that created them
+	owned := shared == nil
+	if owned {
+		if shared, err = CreateShared(); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+	db = shared.DB
+	redis = shared.Redis
+	// Connection pools of the database, checked for exhaustion and published for the stats API
+	instanceHost, _ := os.Hostname()
+	if owned {
+		shared.StartPoolMonitor(ctx, instanceHost+":"+adminConfig.Port, settings.ServiceAdmin, nil)
+	}
+	log.Println("Initialize users")
+	adminUsers = users.CreateUserManager(db.Conn, &jwtConfig)
+	// Carve permissions are separate from query permissions, users without one get their query permission
+	if added, err := adminUsers.MigrateCarvePermissions(); err != nil {
+		log.Printf("error migrating carve permissions %v", err)
+	} else if added > 0 {
+		log.Printf("Added %d carve permissions from query permissions", added)
+	}
+	tagsmgr = shared.Tags
+	envs = shared.Envs
+	settingsmgr = shared.Settings
+	nodesmgr = shared.Nodes
+	queriesmgr = shared.Queries
+	carvesmgr = shared.Carves
+	responsesmgr = shared.Responses
+	webhooksmgr = shared.Webhooks
+	log.Println("Initialize reports")
+	reportsmgr = reports.CreateReports(db.Conn)
+	reportsmgr.TemplateFile = reportsTemplateFile
+	reportsmgr.PDFConverter = reportsPDFConverter
+	reportsmgr.EnvLocale = func(environment string) string {
+		env, err := envs.Get(environment)
+		if err != nil {
+			return ""
+		}
+		return env.Locale
+	}
+	if reportsStorage == reports.StorageS3 {
+		if carvers3 == nil {
+			log.Fatalf("S3 storage for reports needs the S3 carver")
+		}
+		reportsmgr.S3 = reports.CreateReportsS3(carvers3.Client, carvers3.S3Config.Bucket)
+	}
+	if smtpFlag {
+		reportsmgr.SMTP = &smtpConfig
+	}
+	deadletters = shared.DeadLetters
+	log.Println("Initialize sessions")
+	sessionsmgr = sessions.CreateSessionManager(db.Conn, projectName, adminConfig.SessionKey)
+	sessionsmgr.SetURLPrefix(adminConfig.URLPrefix)
+	log.Println("Loading service settings")
+	if err := loadingSettings(settingsmgr); err != nil {
+		log.Fatalf("Error loading settings - %v", err)
+	}
+	log.Println("Loading service metrics")
+	adminMetrics, err = loadingMetrics(settingsmgr)
+	if err != nil {
+		log.Fatalf("Error loading metrics - %v", err)
+	}
+
+	// Start SAML Middleware if we are using SAML
+	if adminConfig.Auth == settings.AuthSAML {
+		if settingsmgr.DebugService(settings.ServiceAdmin) {
+			log.Println("DebugService: SAML keypair")
+		}
+		// Initialize SAML keypair to sign SAML Request.
+		var err error
+		samlData, err = keypairSAML(samlConfig)
+		if err != nil {
+			log.Fatalf("Can not initialize SAML keypair %s", err)
+		}
+		samlMiddleware, err = samlsp.New(samlsp.Options{
+			URL:               *samlData.RootURL,
+			Key:               samlData.KeyPair.PrivateKey.(*rsa.PrivateKey),
+			Certificate:       samlData.KeyPair.Leaf,
+			IDPMetadata:       samlData.IdpMetadata,
+			AllowIDPInitiated: true,
+		})
+		if err != nil {
+			log.Fatalf("Can not initialize SAML Middleware %s", err)
+		}
+	}
+
+	// FIXME Redis cache - Ticker to cleanup sessions
+	// FIXME splay this?
+	go func() {
+		_t := settingsmgr.CleanupSessions()
+		if _t == 0 {
+			_t = int64(defaultRefresh)
+		}
+		for {
+			if settingsmgr.DebugService(settings.ServiceAdmin) {
+				log.Println("DebugService: Cleaning up sessions")
+			}
+			sessionsmgr.Cleanup()
+			if err := envs.CleanScheduleOverrides(); err != nil {
+				log.Printf("error cleaning schedule overrides %v", err)
+			}
+			// Expired denylist entries are lifted and recorded in the activity of their environment
+			lifted, err := envs.LiftExpiredScheduleDenylist()
+			if err != nil {
+				log.Printf("error lifting expired denylist entries %v", err)
+			}
+			for _, d := range lifted {
+				if err := envs.RecordActivity(d.EnvironmentID, environments.ActivityDenylist, serviceName, "expired and lifted from denylist "+d.Target(), "", ""); err != nil {
+					log.Printf("error recording activity %v", err)
+				}
+			}
+			if days := settingsmgr.DecommissionGraceDays(); days > 0 {
+				nodesmgr.DecommissionGrace = time.Duration(days) * 24 * time.Hour
+			}
+			if err := nodesmgr.CheckDecommissions(); err != nil {
+				log.Printf("error checking decommissioned nodes %v", err)
+			}
+			time.Sleep(time.Duration(_t) * time.Second)
+		}
+	}()
+
+	// Scheduled fleet reports
+	reportsmgr.StartScheduler(ctx, time.Duration(reportsInterval)*time.Minute, settingsmgr.InactiveHours)
+
+	// Recurring carves for legal holds
+	queriesmgr.StartRecurringCarves(ctx, queries.DefaultRecurringInterval, settingsmgr.InactiveHours)
+
+	// Queries stop being distributed when they expire
+	queriesmgr.StartExpiration(ctx, queries.DefaultExpirationInterval)
+
+	// Daily node count history, only taken by the leader instance
+	historyEnvs := func() ([]uint, error) {
+		envAll, err := envs.All()
+		if err != nil {
+			return nil, err
+		}
+		ids := make([]uint, 0, len(envAll))
+		for _, e := range envAll {
+			ids = append(ids, e.ID)
+		}
+		return ids, nil
+	}
+	historyLeader := backend.CreateLeaderLock(db.Conn, "node-history", 2*nodes.DefaultHistoryInterval)
+	nodesmgr.StartHistory(ctx, historyLeader, nodes.DefaultHistoryInterval, historyEnvs, settingsmgr.InactiveHours, settingsmgr.HistoryRetentionDays)
+	// History of environments and tags of nodes is compliance evidence, pruned only after a long retention
+	membershipLeader := backend.CreateLeaderLock(db.Conn, "node-membership", 2*nodes.DefaultMembershipInterval)
+	nodesmgr.StartMembershipPrune(ctx, membershipLeader, nodes.DefaultMembershipInterval, settingsmgr.MembershipRetentionDays)
+	// Configuration lineage of nodes keeps the last point before the retention, so the served configuration is known
+	lineageLeader := backend.CreateLeaderLock(db.Conn, "config-lineage", 2*environments.DefaultLineageInterval)
+	envs.StartLineagePrune(ctx, lineageLeader, environments.DefaultLineageInterval, settingsmgr.LineageRetentionDays)
+	// Downloads of carves, query results and node data are reported for their retention
+	accessLeader := backend.CreateLeaderLock(db.Conn, "data-access", 2*environments.DefaultAccessInterval)
+	envs.StartAccessPrune(ctx, accessLeader, environments.DefaultAccessInterval, settingsmgr.AccessRetentionDays)
+	// Hosts flagged as looping are cleared after the quiet period of their environment
+	enrollLoopsQuiet := func() (map[uint]time.Duration, error) {
+		envAll, err := envs.All()
+		if err != nil {
+			return nil, err
+		}
+		quiet := make(map[uint]time.Duration, len(envAll))
+		for _, e := range envAll {
+			quiet[e.ID] = nodes.NewEnrollLoopConfig(e.EnrollLoopLimit, e.EnrollLoopQuiet, e.EnrollBackoff).Quiet
+		}
+		return quiet, nil
+	}
+	enrollLoopsLeader := backend.CreateLeaderLock(db.Conn, "enroll-loops", 2*nodes.DefaultEnrollLoopInterval)
+	nodesmgr.StartEnrollLoopCleanup(ctx, enrollLoopsLeader, nodes.DefaultEnrollLoopInterval, enrollLoopsQuiet)
+	// Duplicate nodes of the same host are proposed for review, with the thresholds in settings
+	mergeLeader := backend.CreateLeaderLock(db.Conn, "node-merges", 2*nodes.DefaultMergeInterval)
+	nodesmgr.StartMergeScoring(ctx, mergeLeader, nodes.DefaultMergeInterval, historyEnvs, func() nodes.MergeThresholds {
+		return nodes.NewMergeThresholds(settingsmgr.DuplicateScore(), settingsmgr.DuplicateHostnameDistance())
+	})
+
+	// Paused nodes get queries and carves again when their pause expires, only by the leader instance
+	pausesLeader := backend.CreateLeaderLock(db.Conn, "node-pauses", 2*nodes.DefaultPauseInterval)
+	nodesmgr.StartPauseExpiry(ctx, pausesLeader, nodes.DefaultPauseInterval, func(p nodes.NodePause) {
+		if err := envs.RecordActivity(p.EnvironmentID, environments.ActivityPauses, serviceName, nodes.PauseSummary(p), "", ""); err != nil {
+			log.Printf("error recording activity for expired pause %v", err)
+		}
+	})
+
+	// Items deleted before the trash window are purged, only by the leader instance
+	if err := backend.MigrateTrash(db.Conn); err != nil {
+		log.Fatalf("%v", err)
+	}
+	trashLeader := backend.CreateLeaderLock(db.Conn, "trash-purge", 2*backend.DefaultTrashInterval)
+	backend.StartTrashPurge(ctx, trashLeader, backend.DefaultTrashInterval, settingsmgr.TrashRetentionDays, queriesmgr.PurgeTrash, tagsmgr.PurgeTrash)
+
+	// Storage of environments measured with table statistics and counters, only by the leader instance
+	adminEvents := cache.NewEventBus(instanceHost+":"+adminConfig.Port, &cache.RedisEvents{Redis: redis})
+	storageSources := map[string]environments.StorageSource{
+		environments.StorageNodes: func(_ []environments.TLSEnvironment) (map[uint]int64, error) {
+			return nodesmgr.LatestTotals()
+		},
+		environments.StorageStatus:  envs.CounterSource(environments.StorageStatus),
+		environments.StorageResults: envs.CounterSource(environments.StorageResults),
+		environments.StorageQueries: envs.CounterSource(environments.StorageQueries),
+		environments.StorageCarves: func(_ []environments.TLSEnvironment) (map[uint]int64, error) {
+			return carvesmgr.SizeByEnvironment()
+		},
+		environments.StorageAudit: envs.ActivitySource,
+	}
+	storageLeader := backend.CreateLeaderLock(db.Conn, "storage-usage", 2*environments.DefaultStorageInterval)
+	envs.StartStorage(ctx, storageLeader, environments.DefaultStorageInterval, storageSources, func(env environments.TLSEnvironment, quota environments.StorageQuota) {
+		summary := "storage quota lifted"
+		if quota.Exceeded {
+			summary = "storage quota exceeded"
+		}
+		summary = fmt.Sprintf("%s, %s of %s used", summary, utils.BytesReceivedConversion(int(quota.UsedBytes)), utils.BytesReceivedConversion(int(quota.MaxBytes)))
+		if err := envs.RecordActivity(env.ID, environments.ActivityStorage, serviceName, summary, "", quota.Actions); err != nil {
+			log.Printf("error recording activity for %s %v", env.Name, err)
+		}
+		// Muted quotas are still recorded, but not notified
+		if _, muted := responsesmgr.Muted(env.ID, "", responses.MuteStorage, ""); quota.HasAction(environments.QuotaNotify) && !muted {
+			go environments.NotifyStorageQuota(settingsmgr.StorageQuotaWebhook(), env, quota)
+		}
+		// TLS instances pause or resume the always logger
+		if _, err := adminEvents.Publish(ctx, cache.EventEnvironment, env.UUID, environments.ActivityStorage); err != nil {
+			log.Printf("error publishing storage event %v", err)
+		}
+	})
+	// Expired mutes notified to who set them, only by the leader instance
+	mutesLeader := backend.CreateLeaderLock(db.Conn, "mute-expiry", 2*responses.DefaultMuteExpiryInterval)
+	responsesmgr.StartMuteExpiry(ctx, mutesLeader, responses.DefaultMuteExpiryInterval, func(mute responses.Mute) {
+		envName := fmt.Sprintf("%d", mute.EnvironmentID)
+		if envAll, err := envs.All(); err == nil {
+			for _, e := range envAll {
+				if e.ID == mute.EnvironmentID {
+					envName = e.Name
+				}
+			}
+		}
+		if err := envs.RecordActivity(mute.EnvironmentID, environments.ActivityMutes, serviceName, "mute of "+mute.Scope()+" expired", "", ""); err != nil {
+			log.Printf("error recording activity for expired mute %v", err)
+		}
+		go responses.NotifyMuteExpired(settingsmgr.MuteExpiryWebhook(), envName, mute)
+	})
+	// Webhook deliveries sent and retried only by the leader instance
+	webhooksLeader := backend.CreateLeaderLock(db.Conn, "webhooks", 2*webhooks.DefaultDeliveryInterval)
+	webhooksmgr.StartDelivery(ctx, webhooksLeader, webhooks.DefaultDeliveryInterval)
+	// Scheduled changes of the configuration applied when due, only by the leader instance
+	scheduledLeader := backend.CreateLeaderLock(db.Conn, "config-schedule", 2*environments.DefaultScheduledInterval)
+	envs.StartScheduledChanges(ctx, scheduledLeader, environments.DefaultScheduledInterval, func(env environments.TLSEnvironment, change environments.ScheduledChange, err error) {
+		if err != nil {
+			return
+		}
+		if err := redis.InvalidateResponses(cache.ResponseGroupEnvironments); err != nil {
+			log.Printf("error invalidating cached responses %v", err)
+		}
+		if err := redis.InvalidateEnvsCached(ctx); err != nil {
+			log.Printf("error invalidating cached environments %v", err)
+		}
+		if _, err := adminEvents.Publish(ctx, cache.EventEnvironment, env.UUID, change.Section); err != nil {
+			log.Printf("error publishing scheduled change event %v", err)
+		}
+	})
+	// Packs synced from the Git repositories of environments, only by the leader instance
+	packSyncLeader := backend.CreateLeaderLock(db.Conn, "pack-sync", 2*environments.DefaultPackSyncTimeout)
+	envs.StartPackSync(ctx, packSyncLeader, environments.DefaultPackSyncInterval, environments.GitFetcher(packCredentialsDir), func(env environments.TLSEnvironment, result environments.PackSyncResult, err error) {
+		if err != nil || !result.Changed() {
+			return
+		}
+		if err := redis.InvalidateResponses(cache.ResponseGroupEnvironments); err != nil {
+			log.Printf("error invalidating cached responses %v", err)
+		}
+		if err := redis.InvalidateEnvsCached(ctx); err != nil {
+			log.Printf("error invalidating cached environments %v", err)
+		}
+		if _, err := adminEvents.Publish(ctx, cache.EventEnvironment, env.UUID, environments.ActivityPacks); err != nil {
+			log.Printf("error publishing pack sync event %v", err)
+		}
+	})
+
+	// Tag jobs in batches, jobs of instances that stopped are resumed when their lease expires
+	tags.NewTagJobRunner(tagsmgr, settingsmgr.TagJobBatchSize).Start(ctx, tags.DefaultTagJobInterval)
+
+	// Delays and lockouts of failed logins, shared with the API through redis
+	loginThrottle := &users.LoginThrottle{
+		Counters: redis,
+		Lockouts: adminUsers,
+		Policy: func() users.LoginPolicy {
+			return users.NewLoginPolicy(settingsmgr.LoginMaxFailures(), settingsmgr.LoginLockoutMinutes())
+		},
+		Notify: func(lockout users.LoginLockout) {
+			go users.NotifyLockout(settingsmgr.LoginLockoutWebhook(), settings.ServiceAdmin, lockout)
+		},
+	}
+
+	// First-run setup, only while there are no users or an interrupted setup can be resumed
+	setupPending, err := adminUsers.SetupPending()
+	if err != nil {
+		log.Fatalf("Error checking setup - %v", err)
+	}
+	if setupPending {
+		if setupToken == "" {
+			setupToken = utils.GenRandomString(32)
+			log.Printf("First-run setup is available at %s%s?token=%s", adminConfig.URLPrefix, setupPath, setupToken)
+		} else {
+			log.Printf("First-run setup is available at %s%s with the provided token", adminConfig.URLPrefix, setupPath)
+		}
+	}
+
+	// Initialize Admin handlers before router
+	handlersAdmin = handlers.CreateHandlersAdmin(
+		handlers.WithDB(db.Conn),
+		handlers.WithEnvs(envs),
+		handlers.WithUsers(adminUsers),
+		handlers.WithTags(tagsmgr),
+		handlers.WithNodes(nodesmgr),
+		handlers.WithQueries(queriesmgr),
+		handlers.WithCarves(carvesmgr),
+		handlers.WithReports(reportsmgr),
+		handlers.WithDeadLetters(deadletters),
+		handlers.WithResponses(responsesmgr),
+		handlers.WithWebhooks(webhooksmgr),
+		handlers.WithSettings(settingsmgr),
+		handlers.WithMetrics(adminMetrics),
+		handlers.WithCache(redis),
+		handlers.WithEvents(adminEvents),
+		handlers.WithSessions(sessionsmgr),
+		handlers.WithLoginThrottle(loginThrottle),
+		handlers.WithVersion(serviceVersion),
+		handlers.WithBuildInfo(version.Info(serviceName)),
+		handlers.WithOsqueryVersion(osqueryTablesVersion),
+		handlers.WithTemplates(templatesFolder),
+		handlers.WithStaticLocation(staticOffline),
+		handlers.WithOsqueryTables(osqueryTables),
+		handlers.WithCarvesFolder(carvedFilesFolder),
+		handlers.WithAdminConfig(&adminConfig),
+		handlers.WithURLPrefix(adminConfig.URLPrefix),
+		handlers.WithSetupToken(setupToken),
+		handlers.WithStatusTokenSecret(statusTokenSecret),
+	)
+
+	// Serve under the URL path prefix, if any, and compress responses, unless it is disabled
+	handlerAdmin := adminRouter(setupPending)
+	if !compressionDisabled {
+		handlerAdmin = utils.CompressHandler(handlerAdmin, utils.CompressConfig{MinSize: compressionMinSize})
+	}
+
+	// Launch HTTP server for admin
+	serviceAdmin := adminConfig.Listener + ":" + adminConfig.Port
+	srv := &http.Server{
+		Addr:    serviceAdmin,
+		Handler: handlerAdmin,
+	}
+	// Stop gracefully, finishing pending requests
+	idleConnsClosed := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		log.Println("Shutting down service...")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("error shutting down HTTP server %v", err)
+		}
+		if owned {
+			if err := shared.Stop(shutdownTimeout); err != nil {
+				log.Printf("%v", err)
+			}
+		}
+		close(idleConnsClosed)
+	}()
+	if tlsServer {
+		cfg, err := utils.TLSConfig(utils.TLSOptions{
+			Profile:               tlsProfile,
+			MinVersion:            tlsMinVersion,
+			MaxVersion:            tlsMaxVersion,
+			CipherSuites:          splitValues(tlsCiphers),
+			Curves:                splitValues(tlsCurves),
+			DisableSessionTickets: tlsNoTickets,
+		})
+		if err != nil {
+			log.Fatalf("Invalid TLS configuration - %v", err)
+		}
+		log.Printf("TLS %s", utils.TLSSummary(tlsProfile, cfg))
+		srv.TLSConfig = cfg
+		srv.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler), 0)
+		log.Printf("%s - HTTPS listening %s", version.Info(serviceName), serviceAdmin)
+		if err := srv.ListenAndServeTLS(tlsCertFile, tlsKeyFile); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	} else {
+		log.Printf("%s - HTTP listening %s", version.Info(serviceName), serviceAdmin)
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}
+	<-idleConnsClosed
+}
+
+// Helper to create the router of the admin service, served under the URL path prefix if any
+func adminRouter(setupPending bool) http.Handler {
+	// ////////////////////////// ADMIN
+	if settingsmgr.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Creating router")
+	}
+	// Create router for admin
+	routerAdmin := mux.NewRouter()
+
+	// ///////////////////////// UNAUTHENTICATED CONTENT
+	if settingsmgr.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Unauthenticated content")
+	}
+	// Admin: login only if local auth is enabled
+	if adminConfig.Auth != settings.AuthNone {
+		// login
+		routerAdmin.HandleFunc(loginPath, handlersAdmin.LoginHandler).Methods("GET")
+		routerAdmin.HandleFunc(loginPath, handlersAdmin.LoginPOSTHandler).Methods("POST")
+	}
+	// Admin: first-run setup, never exposed once there are users and setup is completed
+	if setupPending {
+		routerAdmin.HandleFunc(setupPath, handlersAdmin.SetupGETHandler).Methods("GET")
+		routerAdmin.HandleFunc(setupPath, handlersAdmin.SetupPOSTHandler).Methods("POST")
+	}
+	// Admin: health of service
+	routerAdmin.HandleFunc(healthPath, handlersAdmin.HealthHandler).Methods("GET")
+	// Admin: version and build of service
+	routerAdmin.HandleFunc(versionPath, handlersAdmin.VersionHandler).Methods("GET")
+	// Admin: error
+	routerAdmin.HandleFunc(errorPath, handlersAdmin.ErrorHandler).Methods("GET")
+	// Admin: forbidden
+	routerAdmin.HandleFunc(forbiddenPath, handlersAdmin.ForbiddenHandler).Methods("GET")
+	// Admin: status of nodes with status tokens
+	routerAdmin.HandleFunc(nodes.StatusTokenPath+"{token}", handlersAdmin.StatusHandler).Methods("GET")
+	// Admin: favicon
+	routerAdmin.HandleFunc(faviconPath, handlersAdmin.FaviconHandler)
+	// Admin: static
+	routerAdmin.PathPrefix("/static/").Handler(
+		http.StripPrefix("/static", http.FileServer(http.Dir(staticFilesFolder))))
+
+	// ///////////////////////// AUTHENTICATED CONTENT
+	if settingsmgr.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Authenticated content")
+	}
+	// Admin: JSON data for environments
+	routerAdmin.Handle("/json/environment/{env}/{target}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.JSONEnvironmentHandler))).Methods("GET")
+	// Admin: JSON data for the coverage estimate of queries
+	routerAdmin.Handle("/json/coverage/{env}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.JSONCoverageHandler))).Methods("GET")
+	// Admin: JSON data for platforms
+	routerAdmin.Handle("/json/platform/{platform}/{target}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.JSONPlatformHandler))).Methods("GET")
+	// Admin: JSON data for logs
+	routerAdmin.Handle("/json/logs/{type}/{env}/{uuid}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.JSONLogsHandler))).Methods("GET")
+	// Admin: JSON data for query logs
+	routerAdmin.Handle("/json/query/{name}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.JSONQueryLogsHandler))).Methods("GET")
+	// Admin: JSON data for sidebar stats
+	routerAdmin.Handle("/json/stats/{target}/{identifier}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.JSONStatsHandler))).Methods("GET")
+	// Admin: JSON data for node count history
+	routerAdmin.Handle("/json/history/{env}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.JSONHistoryHandler))).Methods("GET")
+	// Admin: JSON data for tags
+	routerAdmin.Handle("/json/tags", handlerAuthCheck(http.HandlerFunc(handlersAdmin.JSONTagsHandler))).Methods("GET")
+	routerAdmin.Handle("/json/tags/jobs/{id}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.JSONTagJobHandler))).Methods("GET")
+	// Admin: table for environments
+	routerAdmin.Handle("/environment/{env}/{target}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.EnvironmentHandler))).Methods("GET")
+	// Admin: table for platforms
+	routerAdmin.Handle("/platform/{platform}/{target}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.PlatformHandler))).Methods("GET")
+	// Admin: dashboard
+	routerAdmin.Handle("/dashboard", handlerAuthCheck(http.HandlerFunc(handlersAdmin.RootHandler))).Methods("GET")
+	// Admin: root
+	routerAdmin.Handle("/", handlerAuthCheck(http.HandlerFunc(handlersAdmin.RootHandler))).Methods("GET")
+	// Admin: find nodes across environments
+	routerAdmin.Handle("/search", handlerAuthCheck(http.HandlerFunc(handlersAdmin.SearchGETHandler))).Methods("GET")
+	// Admin: compare two nodes
+	routerAdmin.Handle("/nodes/compare", handlerAuthCheck(http.HandlerFunc(handlersAdmin.NodeCompareGETHandler))).Methods("GET")
+	// Admin: node view
+	routerAdmin.Handle("/node/{uuid}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.NodeHandler))).Methods("GET")
+	// Admin: configuration lineage of a node
+	routerAdmin.Handle("/node/{uuid}/config-history", handlerAuthCheck(http.HandlerFunc(handlersAdmin.NodeConfigHistoryGETHandler))).Methods("GET")
+	// Admin: multi node action
+	routerAdmin.Handle("/node/actions", handlerAuthCheck(http.HandlerFunc(handlersAdmin.NodeActionsPOSTHandler))).Methods("POST")
+	// Admin: schedule overrides for a node
+	routerAdmin.Handle("/node/schedule-override", handlerAuthCheck(http.HandlerFunc(handlersAdmin.ScheduleOverridePOSTHandler))).Methods("POST")
+	// Admin: status tokens for a node
+	routerAdmin.Handle("/node/identity", handlerAuthCheck(http.HandlerFunc(handlersAdmin.NodeIdentityPOSTHandler))).Methods("POST")
+	routerAdmin.Handle("/node/status-token", handlerAuthCheck(http.HandlerFunc(handlersAdmin.StatusTokenPOSTHandler))).Methods("POST")
+	// Admin: run queries
+	routerAdmin.Handle("/query/{env}/run", handlerAuthCheck(http.HandlerFunc(handlersAdmin.QueryRunGETHandler))).Methods("GET")
+	routerAdmin.Handle("/query/{env}/run", handlerAuthCheck(http.HandlerFunc(handlersAdmin.QueryRunPOSTHandler))).Methods("POST")
+	// Admin: list queries
+	routerAdmin.Handle("/query/{env}/list", handlerAuthCheck(http.HandlerFunc(handlersAdmin.QueryListGETHandler))).Methods("GET")
+	// Admin: saved queries
+	routerAdmin.Handle("/query/{env}/saved", handlerAuthCheck(http.HandlerFunc(handlersAdmin.SavedQueriesGETHandler))).Methods("GET")
+	// Admin: concurrency groups of queries
+	routerAdmin.Handle("/query/{env}/groups", handlerAuthCheck(http.HandlerFunc(handlersAdmin.QueryGroupsGETHandler))).Methods("GET")
+	routerAdmin.Handle("/query/{env}/groups", handlerAuthCheck(http.HandlerFunc(handlersAdmin.QueryGroupsPOSTHandler))).Methods("POST")
+	// Admin: query policies
+	routerAdmin.Handle("/query/{env}/policies", handlerAuthCheck(http.HandlerFunc(handlersAdmin.QueryPoliciesGETHandler))).Methods("GET")
+	routerAdmin.Handle("/query/{env}/policies", handlerAuthCheck(http.HandlerFunc(handlersAdmin.QueryPoliciesPOSTHandler))).Methods("POST")
+	// Admin: trash of deleted items
+	routerAdmin.Handle("/trash/{environment}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.TrashGETHandler))).Methods("GET")
+	routerAdmin.Handle("/trash", handlerAuthCheck(http.HandlerFunc(handlersAdmin.TrashPOSTHandler))).Methods("POST")
+	// Admin: query actions
+	routerAdmin.Handle("/query/{env}/actions", handlerAuthCheck(http.HandlerFunc(handlersAdmin.QueryActionsPOSTHandler))).Methods("POST")
+	// Admin: query context
+	routerAdmin.Handle("/query/{env}/context", handlerAuthCheck(http.HandlerFunc(handlersAdmin.QueryContextPOSTHandler))).Methods("POST")
+	// Admin: results visibility of queries
+	routerAdmin.Handle("/query/{env}/visibility", handlerAuthCheck(http.HandlerFunc(handlersAdmin.QueryVisibilityPOSTHandler))).Methods("POST")
+	routerAdmin.Handle("/query/{env}/break-glass", handlerAuthCheck(http.HandlerFunc(handlersAdmin.QueryBreakGlassPOSTHandler))).Methods("POST")
+	// Admin: query JSON
+	routerAdmin.Handle("/query/{env}/json/{target}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.JSONQueryHandler))).Methods("GET")
+	// Admin: query profile
+	routerAdmin.Handle("/query/{env}/profile/{name}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.QueryProfileHandler))).Methods("GET")
+	routerAdmin.Handle("/query/{env}/profile/{name}/launch", handlerAuthCheck(http.HandlerFunc(handlersAdmin.QueryProfileLaunchPOSTHandler))).Methods("POST")
+	// Admin: query logs
+	routerAdmin.Handle("/query/{env}/logs/{name}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.QueryLogsHandler))).Methods("GET")
+	routerAdmin.Handle("/query/{env}/logs/{name}/export", handlerAuthCheck(http.HandlerFunc(handlersAdmin.QueryExportHandler))).Methods("GET")
+	routerAdmin.Handle("/query/{env}/share", handlerAuthCheck(http.HandlerFunc(handlersAdmin.QuerySharePOSTHandler))).Methods("POST")
+	routerAdmin.Handle("/query/{env}/shares/{name}/download", handlerAuthCheck(http.HandlerFunc(handlersAdmin.QueryShareDownloadHandler))).Methods("GET")
+	routerAdmin.Handle("/query/{env}/rehydrate", handlerAuthCheck(http.HandlerFunc(handlersAdmin.QueryRehydratePOSTHandler))).Methods("POST")
+	routerAdmin.Handle("/query/{env}/exports/cancel", handlerAuthCheck(http.HandlerFunc(handlersAdmin.QueryExportCancelPOSTHandler))).Methods("POST")
+	// Admin: carve files
+	routerAdmin.Handle("/carves/{env}/run", handlerAuthCheck(http.HandlerFunc(handlersAdmin.CarvesRunGETHandler))).Methods("GET")
+	routerAdmin.Handle("/carves/{env}/run", handlerAuthCheck(http.HandlerFunc(handlersAdmin.CarvesRunPOSTHandler))).Methods("POST")
+	// Admin: list carves
+	routerAdmin.Handle("/carves/{env}/list", handlerAuthCheck(http.HandlerFunc(handlersAdmin.CarvesListGETHandler))).Methods("GET")
+	// Admin: carve limits of an environment
+	routerAdmin.Handle("/carves/{env}/limits", handlerAuthCheck(http.HandlerFunc(handlersAdmin.CarveLimitsPOSTHandler))).Methods("POST")
+	// Admin: recurring carves for legal holds
+	routerAdmin.Handle("/carves/{env}/recurring", handlerAuthCheck(http.HandlerFunc(handlersAdmin.RecurringCarvesGETHandler))).Methods("GET")
+	routerAdmin.Handle("/carves/{env}/recurring/{name}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.RecurringCarvesGETHandler))).Methods("GET")
+	routerAdmin.Handle("/carves/{env}/recurring", handlerAuthCheck(http.HandlerFunc(handlersAdmin.RecurringCarvesPOSTHandler))).Methods("POST")
+	// Admin: carves actions
+	routerAdmin.Handle("/carves/{env}/actions", handlerAuthCheck(http.HandlerFunc(handlersAdmin.CarvesActionsPOSTHandler))).Methods("POST")
+	// Admin: carves JSON
+	routerAdmin.Handle("/carves/{env}/json/{target}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.JSONCarvesHandler))).Methods("GET")
+	// Admin: carves details
+	routerAdmin.Handle("/carves/{env}/details/{name}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.CarvesDetailsHandler))).Methods("GET")
+	// Admin: carves download
+	routerAdmin.Handle("/carves/{env}/download/{sessionid}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.CarvesDownloadHandler))).Methods("GET")
+	// Admin: carves single file download
+	routerAdmin.Handle("/carves/{env}/files/{sessionid}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.CarvesFileDownloadHandler))).Methods("GET")
+	// Admin: nodes configuration
+	routerAdmin.Handle("/conf/{environment}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.ConfGETHandler))).Methods("GET")
+	routerAdmin.Handle("/conf/{environment}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.ConfPOSTHandler))).Methods("POST")
+	routerAdmin.Handle("/conf/{environment}/profiles", handlerAuthCheck(http.HandlerFunc(handlersAdmin.ProfilesPOSTHandler))).Methods("POST")
+	routerAdmin.Handle("/conf/{environment}/denylist", handlerAuthCheck(http.HandlerFunc(handlersAdmin.DenylistPOSTHandler))).Methods("POST")
+	routerAdmin.Handle("/conf/{environment}/quickactions", handlerAuthCheck(http.HandlerFunc(handlersAdmin.QuickActionsPOSTHandler))).Methods("POST")
+	routerAdmin.Handle("/quickactions/{environment}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.QuickActionRunPOSTHandler))).Methods("POST")
+	routerAdmin.Handle("/conf/{environment}/storage", handlerAuthCheck(http.HandlerFunc(handlersAdmin.StorageQuotaPOSTHandler))).Methods("POST")
+	routerAdmin.Handle("/conf/{environment}/windows-events", handlerAuthCheck(http.HandlerFunc(handlersAdmin.WindowsEventsPOSTHandler))).Methods("POST")
+	routerAdmin.Handle("/conf/{environment}/apikeys", handlerAuthCheck(http.HandlerFunc(handlersAdmin.APIKeysPOSTHandler))).Methods("POST")
+	routerAdmin.Handle("/conf/{environment}/versions", handlerAuthCheck(http.HandlerFunc(handlersAdmin.ConfVersionsGETHandler))).Methods("GET")
+	routerAdmin.Handle("/conf/{environment}/rollback", handlerAuthCheck(http.HandlerFunc(handlersAdmin.ConfRollbackPOSTHandler))).Methods("POST")
+	routerAdmin.Handle("/conf/{environment}/impact", handlerAuthCheck(http.HandlerFunc(handlersAdmin.ConfImpactPOSTHandler))).Methods("POST")
+	routerAdmin.Handle("/conf/{environment}/scheduled", handlerAuthCheck(http.HandlerFunc(handlersAdmin.ConfScheduledPOSTHandler))).Methods("POST")
+	routerAdmin.Handle("/intervals/{environment}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.IntervalsPOSTHandler))).Methods("POST")
+	// Admin: nodes enroll
+	routerAdmin.Handle("/enroll/{environment}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.EnrollGETHandler))).Methods("GET")
+	routerAdmin.Handle("/enroll/{environment}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.EnrollPOSTHandler))).Methods("POST")
+	routerAdmin.Handle("/enroll/{environment}/fields", handlerAuthCheck(http.HandlerFunc(handlersAdmin.EnrollFieldsPOSTHandler))).Methods("POST")
+	routerAdmin.Handle("/expiration/{environment}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.ExpirationPOSTHandler))).Methods("POST")
+	// Admin: response actions
+	routerAdmin.Handle("/responses/{environment}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.ResponsesGETHandler))).Methods("GET")
+	routerAdmin.Handle("/responses/{environment}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.ResponsesPOSTHandler))).Methods("POST")
+	// Admin: pack sync
+	routerAdmin.Handle("/pack-sync/{environment}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.PackSyncGETHandler))).Methods("GET")
+	routerAdmin.Handle("/pack-sync/{environment}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.PackSyncPOSTHandler))).Methods("POST")
+	// Admin: webhooks
+	routerAdmin.Handle("/webhooks/{environment}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.WebhooksGETHandler))).Methods("GET")
+	routerAdmin.Handle("/webhooks/{environment}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.WebhooksPOSTHandler))).Methods("POST")
+	// Admin: rejected enrollments
+	routerAdmin.Handle("/rejections/{environment}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.RejectionsGETHandler))).Methods("GET")
+	routerAdmin.Handle("/rejections/{environment}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.RejectionsPOSTHandler))).Methods("POST")
+	routerAdmin.Handle("/enroll-loops/{environment}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.EnrollLoopsGETHandler))).Methods("GET")
+	routerAdmin.Handle("/node-merges/{environment}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.NodeMergesGETHandler))).Methods("GET")
+	routerAdmin.Handle("/node-merges/{environment}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.NodeMergesPOSTHandler))).Methods("POST")
+	// Admin: environment activity
+	routerAdmin.Handle("/activity/{environment}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.ActivityGETHandler))).Methods("GET")
+	routerAdmin.Handle("/activity/{environment}/{id}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.ActivityDiffGETHandler))).Methods("GET")
+	// Admin: server settings
+	routerAdmin.Handle("/settings/{service}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.SettingsGETHandler))).Methods("GET")
+	routerAdmin.Handle("/settings/{service}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.SettingsPOSTHandler))).Methods("POST")
+	// Admin: manage environments
+	routerAdmin.Handle("/environments", handlerAuthCheck(http.HandlerFunc(handlersAdmin.EnvsGETHandler))).Methods("GET")
+	routerAdmin.Handle("/environments", handlerAuthCheck(http.HandlerFunc(handlersAdmin.EnvsPOSTHandler))).Methods("POST")
+	routerAdmin.Handle("/environments/templates", handlerAuthCheck(http.HandlerFunc(handlersAdmin.EnvTemplatesGETHandler))).Methods("GET")
+	routerAdmin.Handle("/environments/templates", handlerAuthCheck(http.HandlerFunc(handlersAdmin.EnvTemplatesPOSTHandler))).Methods("POST")
+	routerAdmin.Handle("/environments/templates/{name}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.EnvTemplateGETHandler))).Methods("GET")
+	// Admin: manage users
+	routerAdmin.Handle("/users", handlerAuthCheck(http.HandlerFunc(handlersAdmin.UsersGETHandler))).Methods("GET")
+	routerAdmin.Handle("/users", handlerAuthCheck(http.HandlerFunc(handlersAdmin.UsersPOSTHandler))).Methods("POST")
+	routerAdmin.Handle("/users/teams", handlerAuthCheck(http.HandlerFunc(handlersAdmin.UserTeamsPOSTHandler))).Methods("POST")
+	routerAdmin.Handle("/users/permissions/{username}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.PermissionsGETHandler))).Methods("GET")
+	routerAdmin.Handle("/users/permissions/{username}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.PermissionsPOSTHandler))).Methods("POST")
+	routerAdmin.Handle("/users/permissions/{username}/bulk", handlerAuthCheck(http.HandlerFunc(handlersAdmin.PermissionsBulkPOSTHandler))).Methods("POST")
+	// Admin: manage tags
+	routerAdmin.Handle("/tags", handlerAuthCheck(http.HandlerFunc(handlersAdmin.TagsGETHandler))).Methods("GET")
+	routerAdmin.Handle("/tags", handlerAuthCheck(http.HandlerFunc(handlersAdmin.TagsPOSTHandler))).Methods("POST")
+	routerAdmin.Handle("/tags/nodes", handlerAuthCheck(http.HandlerFunc(handlersAdmin.TagNodesPOSTHandler))).Methods("POST")
+	// Admin: fleet reports
+	routerAdmin.Handle("/reports", handlerAuthCheck(http.HandlerFunc(handlersAdmin.ReportsGETHandler))).Methods("GET")
+	routerAdmin.Handle("/reports", handlerAuthCheck(http.HandlerFunc(handlersAdmin.ReportsPOSTHandler))).Methods("POST")
+	routerAdmin.Handle("/reports/download/{id}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.ReportsDownloadHandler))).Methods("GET")
+	// Admin: data access report
+	routerAdmin.Handle("/access-log", handlerAuthCheck(http.HandlerFunc(handlersAdmin.AccessLogGETHandler))).Methods("GET")
+	// Admin: dead letters of the logger
+	routerAdmin.Handle("/logs/dlq", handlerAuthCheck(http.HandlerFunc(handlersAdmin.DeadLettersGETHandler))).Methods("GET")
+	routerAdmin.Handle("/logs/dlq", handlerAuthCheck(http.HandlerFunc(handlersAdmin.DeadLettersPOSTHandler))).Methods("POST")
+	// Admin: notices for all users
+	routerAdmin.Handle("/notices", handlerAuthCheck(http.HandlerFunc(handlersAdmin.NoticesGETHandler))).Methods("GET")
+	routerAdmin.Handle("/notices", handlerAuthCheck(http.HandlerFunc(handlersAdmin.NoticesPOSTHandler))).Methods("POST")
+	// Admin: request statistics of osctrl-tls
+	routerAdmin.Handle("/service-health", handlerAuthCheck(http.HandlerFunc(handlersAdmin.ServiceHealthGETHandler))).Methods("GET")
+	// Admin: query templates
+	routerAdmin.Handle("/templates", handlerAuthCheck(http.HandlerFunc(handlersAdmin.QueryTemplatesGETHandler))).Methods("GET")
+	routerAdmin.Handle("/templates", handlerAuthCheck(http.HandlerFunc(handlersAdmin.QueryTemplatesPOSTHandler))).Methods("POST")
+	// Admin: manage tokens
+	routerAdmin.Handle("/tokens/{username}", handlerAuthCheck(http.HandlerFunc(handlersAdmin.TokensGETHandler))).Methods("GET")
+	routerAdmin.Handle("/tokens/{username}/refresh", handlerAuthCheck(http.HandlerFunc(handlersAdmin.TokensPOSTHandler))).Methods("POST")
+	// edit profile
+	routerAdmin.Handle("/profile", handlerAuthCheck(http.HandlerFunc(handlersAdmin.EditProfileGETHandler))).Methods("GET")
+	routerAdmin.Handle("/profile", handlerAuthCheck(http.HandlerFunc(handlersAdmin.EditProfilePOSTHandler))).Methods("POST")
+	// logout
+	routerAdmin.Handle("/logout", handlerAuthCheck(http.HandlerFunc(handlersAdmin.LogoutPOSTHandler))).Methods("POST")
+	// SAML ACS
+	if adminConfig.Auth == settings.AuthSAML {
+		routerAdmin.PathPrefix("/saml/").Handler(samlMiddleware)
+	}
+
+	return utils.URLPrefixHandler(adminConfig.URLPrefix, routerAdmin)
+}
+
+// Action to run when no flags are provided to run checks and prepare data
+func cliAction(c *cli.Context) error {
+	// Load configuration if external JSON config file is used
+	if configFlag {
+		adminConfig, err = loadConfiguration(serviceConfigFile, settings.ServiceAdmin)
+		if err != nil {
+			return fmt.Errorf("Failed to load service configuration %s - %s", serviceConfigFile, err)
+		}
+	}
+	// Load redis configuration if external JSON config file is used
+	if redisFlag {
+		redisConfig, err = cache.LoadConfiguration(redisConfigFile, cache.RedisKey)
+		if err != nil {
+			return fmt.Errorf("Failed to load redis configuration - %v", err)
+		}
+	}
+	// Load DB configuration if external JSON config file is used
+	if dbFlag {
+		dbConfig, err = backend.LoadConfiguration(dbConfigFile, backend.DBKey)
+		if err != nil {
+			return fmt.Errorf("Failed to load DB configuration - %v", err)
+		}
+	}
+	// Load SAML configuration if this authentication is used in the service config
+	if adminConfig.Auth == settings.AuthSAML {
+		samlConfig, err = loadSAML(samlConfigFile)
+		if err != nil {
+			return fmt.Errorf("Failed to load SAML configuration - %v", err)
+		}
+	}
+	// Load JWT configuration if external JWT JSON config file is used
+	if jwtFlag {
+		jwtConfig, err = loadJWTConfiguration(jwtConfigFile)
+		if err != nil {
+			return fmt.Errorf("Failed to load JWT configuration - %v", err)
+		}
+	}
+	// Load osquery tables JSON file
+	osqueryTables, err = loadOsqueryTables(osqueryTablesFile)
+	if err != nil {
+		return fmt.Errorf("Failed to load osquery tables - %v", err)
+	}
+	// Load carver configuration if external JSON config file is used
+	if adminConfig.Carver == settings.CarverS3 {
+		if s3CarverConfig.Bucket != "" {
+			carvers3, err = carves.CreateCarverS3(s3CarverConfig)
+		} else {
+			carvers3, err = carves.CreateCarverS3File(carverConfigFile)
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to initiate s3 carver - %v", err)
+		}
+	}
+	// Load SMTP configuration to email reports
+	if smtpFlag {
+		smtpConfig, err = reports.LoadSMTP(smtpConfigFile)
+		if err != nil {
+			return fmt.Errorf("Failed to load SMTP configuration - %v", err)
+		}
+	}
+	return nil
+}
+
+// CreateShared to initialize the managers with the configuration of this service, to share them with
+// the other services running in the same process
+func CreateShared() (*service.Shared, error) {
+	return service.CreateShared(dbConfig, redisConfig, adminConfig.Carver, carvers3)
+}
+
+// Configure to parse the arguments of the service and load its configuration
+func Configure(args []string) error {
+	// Initiate CLI and parse arguments
+	app = cli.NewApp()
+	app.Name = serviceName
+	app.Usage = appDescription
+	app.Version = serviceVersion
+	app.Description = appDescription
+	app.Flags = flags
+	// Define this command for help to exit when help flag is passed
+	app.Commands = []*cli.Command{
+		{
+			Name: "help",
+			Action: func(c *cli.Context) error {
+				cli.ShowAppHelpAndExit(c, 0)
+				return nil
+			},
+		},
+	}
+	app.Action = cliAction
+	return app.Run(args)
+}
+
+// Run to start the service until the context is done, with the managers shared by other services
+// in the same process, or with its own managers if shared is nil
+func Run(ctx context.Context, shared *service.Shared) {
+	osctrlAdminService(ctx, shared)
+}
+
+// Main to run the service as osctrl-admin
+func Main() {
+	if err := Configure(os.Args); err != nil {
+		log.Fatal(err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// Service starts!
+	osctrlAdminService(ctx, nil)
+}
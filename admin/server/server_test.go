@@ -0,0 +1,193 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmpsec/osctrl/admin/handlers"
+	"github.com/jmpsec/osctrl/admin/sessions"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/tags"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Helper to open a mocked postgres database
+func mockDB(t *testing.T) (*gorm.DB, sqlmock.Sqlmock) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db, got error: %v", err)
+	}
+	t.Cleanup(func() { mockDB.Close() })
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: mockDB}), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("unable to create new postgres database: %v", err)
+	}
+	return db, mock
+}
+
+// Helper to prepare the admin service with a mocked database, settings are not found so defaults are used
+func mockAdmin(t *testing.T, prefix string) sqlmock.Sqlmock {
+	db, mock := mockDB(t)
+	settingsDB, _ := mockDB(t)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM information_schema.tables WHERE table_schema = CURRENT_SCHEMA() AND table_name = $1 AND table_type = $2`)).
+		WithArgs("user_sessions", "BASE TABLE").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectExec(`CREATE TABLE "user_sessions" .*`).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`CREATE INDEX IF NOT EXISTS .*`).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`CREATE INDEX IF NOT EXISTS .*`).WillReturnResult(sqlmock.NewResult(1, 1))
+	adminConfig.Auth = settings.AuthDB
+	adminConfig.URLPrefix = prefix
+	settingsmgr = &settings.Settings{DB: settingsDB}
+	adminUsers = &users.UserManager{DB: db}
+	sessionsmgr = sessions.CreateSessionManager(db, projectName, "")
+	sessionsmgr.SetURLPrefix(prefix)
+	staticFilesFolder = t.TempDir()
+	if err := os.MkdirAll(filepath.Join(staticFilesFolder, "js"), 0755); err != nil {
+		t.Fatalf("error creating static folder - %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(staticFilesFolder, "js", "functions.js"), []byte("var urlPrefix"), 0644); err != nil {
+		t.Fatalf("error writing static file - %v", err)
+	}
+	handlersAdmin = handlers.CreateHandlersAdmin(
+		handlers.WithDB(db),
+		handlers.WithUsers(adminUsers),
+		handlers.WithTags(&tags.TagManager{DB: db}),
+		handlers.WithSettings(settingsmgr),
+		handlers.WithSessions(sessionsmgr),
+		handlers.WithAdminConfig(&adminConfig),
+		handlers.WithURLPrefix(prefix),
+	)
+	return mock
+}
+
+// Helper to expect the session of a cookie to be found and the metadata of its user to be updated
+func expectSession(mock sqlmock.Sqlmock, cookie string) {
+	mock.ExpectQuery(`SELECT \* FROM "user_sessions" WHERE cookie = \$1 AND expires_at > \$2`).
+		WithArgs(cookie, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "expires_at", "cookie"}).AddRow(1, "admin", time.Now().Add(time.Hour), cookie))
+}
+
+func expectAuth(mock sqlmock.Sqlmock, cookie string) {
+	expectSession(mock, cookie)
+	mock.ExpectQuery(`SELECT \* FROM "admin_users" WHERE username = \$1`).
+		WithArgs("admin").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "admin"}).AddRow(1, "admin", true))
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "admin_users"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+}
+
+func TestAdminRouterURLPrefix(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	assert.NoError(t, err)
+	for _, prefix := range []string{"", "/osctrl"} {
+		t.Run("prefix "+prefix, func(t *testing.T) {
+			mock := mockAdmin(t, prefix)
+			// Session cookies are secure, they are only sent over TLS
+			srv := httptest.NewTLSServer(adminRouter(false))
+			defer srv.Close()
+			jar, _ := cookiejar.New(nil)
+			client := srv.Client()
+			client.Jar = jar
+			client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			}
+			base := srv.URL + prefix
+			// Static assets are served under the prefix
+			resp, err := client.Get(base + "/static/js/functions.js")
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			// Without session, table JSON redirects to the login under the prefix
+			resp, err = client.Get(base + "/json/tags")
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusFound, resp.StatusCode)
+			assert.Equal(t, prefix+loginPath, resp.Header.Get("Location"))
+			// Login sets the session cookie scoped to the prefix
+			mock.ExpectQuery(`SELECT \* FROM "admin_users" WHERE username = \$1`).
+				WithArgs("admin").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "username", "pass_hash", "admin", "default_env"}).AddRow(1, "admin", string(hash), true, "dev"))
+			mock.ExpectQuery(`SELECT count\(\*\) FROM "admin_users"`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+			mock.ExpectQuery(`SELECT \* FROM "user_permissions"`).
+				WithArgs("admin", "dev").
+				WillReturnRows(sqlmock.NewRows([]string{"username", "access_type", "access_value", "environment"}).AddRow("admin", users.AdminLevel, true, "dev"))
+			mock.ExpectBegin()
+			mock.ExpectQuery(`INSERT INTO "user_sessions"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+			mock.ExpectCommit()
+			resp, err = client.Post(base+loginPath, utils.JSONApplication, strings.NewReader(`{"username":"admin","password":"secret"}`))
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			var login handlers.AdminResponse
+			assert.NoError(t, json.NewDecoder(resp.Body).Decode(&login))
+			resp.Body.Close()
+			assert.Equal(t, prefix+"/environment/dev/active", login.Message)
+			cookies := resp.Cookies()
+			if !assert.Equal(t, 1, len(cookies)) {
+				return
+			}
+			assert.Equal(t, utils.CookiePath(prefix), cookies[0].Path)
+			cookie := cookies[0].Value
+			// Table JSON with the session
+			expectAuth(mock, cookie)
+			mock.ExpectQuery(`SELECT count\(\*\) FROM "admin_users"`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+			mock.ExpectQuery(`SELECT count\(\*\) FROM "admin_users"`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+			mock.ExpectQuery(`SELECT \* FROM "admin_tags"`).WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "production"))
+			resp, err = client.Get(base + "/json/tags")
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			var tagsJSON []tags.AdminTag
+			assert.NoError(t, json.NewDecoder(resp.Body).Decode(&tagsJSON))
+			resp.Body.Close()
+			assert.Equal(t, 1, len(tagsJSON))
+			// POST without the CSRF token of the session is rejected
+			expectAuth(mock, cookie)
+			resp, err = client.Post(base+"/logout", utils.JSONApplication, strings.NewReader(`{"csrftoken":"invalid"}`))
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+			// POST with the CSRF token of the session
+			expectSession(mock, cookie)
+			session, err := sessionsmgr.Get(cookie)
+			assert.NoError(t, err)
+			csrf, _ := session.Values[sessions.CtxCSRF].(string)
+			assert.NotEmpty(t, csrf)
+			expectAuth(mock, cookie)
+			expectSession(mock, cookie)
+			mock.ExpectBegin()
+			mock.ExpectExec(`UPDATE "user_sessions" SET "expires_at"`).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+			resp, err = client.Post(base+"/logout", utils.JSONApplication, strings.NewReader(`{"csrftoken":"`+csrf+`"}`))
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.NoError(t, mock.ExpectationsWereMet())
+			if prefix == "" {
+				return
+			}
+			// The cookie is not sent outside the prefix
+			u, _ := url.Parse(srv.URL + "/json/tags")
+			assert.Equal(t, 0, len(jar.Cookies(u)))
+			// Anything outside the prefix is not found, the prefix itself redirects
+			resp, err = client.Get(srv.URL + "/json/tags")
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+			resp, err = client.Get(srv.URL + prefix)
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusFound, resp.StatusCode)
+			assert.Equal(t, prefix+"/", resp.Header.Get("Location"))
+		})
+	}
+}
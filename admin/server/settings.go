@@ -0,0 +1,262 @@
+package server
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/jmpsec/osctrl/backend"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/metrics"
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/jmpsec/osctrl/queries"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/tags"
+	"github.com/jmpsec/osctrl/users"
+)
+
+// Function to load the metrics settings
+func loadingMetrics(mgr *settings.Settings) (*metrics.Metrics, error) {
+	// Check if service settings for metrics is ready, initialize if so
+	if !mgr.IsValue(settings.ServiceAdmin, settings.ServiceMetrics) {
+		if err := mgr.NewBooleanValue(settings.ServiceAdmin, settings.ServiceMetrics, false); err != nil {
+			return nil, fmt.Errorf("Failed to add %s to configuration: %v", settings.ServiceMetrics, err)
+		}
+	}
+	if mgr.ServiceMetrics(settings.ServiceAdmin) {
+		_mCfg, err := metrics.LoadConfiguration()
+		if err != nil {
+			if err := mgr.SetBoolean(false, settings.ServiceAdmin, settings.ServiceMetrics); err != nil {
+				return nil, fmt.Errorf("Failed to disable metrics: %v", err)
+			}
+			return nil, fmt.Errorf("Failed to initialize metrics: %v", err)
+		}
+		_m, err := metrics.CreateMetrics(_mCfg.Protocol, _mCfg.Host, _mCfg.Port, serviceName)
+		if err != nil {
+			if err := mgr.SetBoolean(false, settings.ServiceAdmin, settings.ServiceMetrics); err != nil {
+				return nil, fmt.Errorf("Failed to disable metrics: %v", err)
+			}
+			return nil, fmt.Errorf("Failed to initialize metrics: %v", err)
+		}
+		return _m, nil
+	}
+	return nil, nil
+}
+
+// Function to load all settings for the service
+func loadingSettings(mgr *settings.Settings) error {
+	// Check if service settings for debug service is ready
+	if mgr.DebugService(settings.ServiceAdmin) {
+		log.Println("DebugService: Initializing settings")
+	}
+	// Check if service settings for debug service is ready
+	if !mgr.IsValue(settings.ServiceAdmin, settings.DebugService) {
+		if err := mgr.NewBooleanValue(settings.ServiceAdmin, settings.DebugService, false); err != nil {
+			return fmt.Errorf("Failed to add %s to settings: %v", settings.DebugService, err)
+		}
+	}
+	// Check if service settings for debug HTTP is ready
+	if !mgr.IsValue(settings.ServiceAdmin, settings.DebugHTTP) {
+		if err := mgr.NewBooleanValue(settings.ServiceAdmin, settings.DebugHTTP, false); err != nil {
+			return fmt.Errorf("Failed to add %s to settings: %v", settings.DebugHTTP, err)
+		}
+	}
+	// Check if service settings for default environment is ready
+	if !mgr.IsValue(settings.ServiceAdmin, settings.DefaultEnv) {
+		if err := mgr.NewStringValue(settings.ServiceAdmin, settings.DefaultEnv, "dev"); err != nil {
+			return fmt.Errorf("Failed to add %s to settings: %v", settings.DefaultEnv, err)
+		}
+	}
+	// Check if service settings for sessions cleanup is ready
+	if !mgr.IsValue(settings.ServiceAdmin, settings.CleanupSessions) {
+		if err := mgr.NewIntegerValue(settings.ServiceAdmin, settings.CleanupSessions, int64(defaultRefresh)); err != nil {
+			return fmt.Errorf("Failed to add %s to configuration: %v", settings.CleanupSessions, err)
+		}
+	}
+	// Check if service settings for node inactive hours is ready
+	if !mgr.IsValue(settings.ServiceAdmin, settings.InactiveHours) {
+		if err := mgr.NewIntegerValue(settings.ServiceAdmin, settings.InactiveHours, int64(defaultInactive)); err != nil {
+			return fmt.Errorf("Failed to add %s to configuration: %v", settings.InactiveHours, err)
+		}
+	}
+	// Check if service settings for decommission grace period is ready
+	if !mgr.IsValue(settings.ServiceAdmin, settings.DecommissionGrace) {
+		if err := mgr.NewIntegerValue(settings.ServiceAdmin, settings.DecommissionGrace, int64(defaultDecommissionGrace)); err != nil {
+			return fmt.Errorf("Failed to add %s to configuration: %v", settings.DecommissionGrace, err)
+		}
+	}
+	// Check if service settings for node count history retention is ready
+	if !mgr.IsValue(settings.ServiceAdmin, settings.HistoryRetention) {
+		if err := mgr.NewIntegerValue(settings.ServiceAdmin, settings.HistoryRetention, int64(nodes.DefaultHistoryRetention)); err != nil {
+			return fmt.Errorf("Failed to add %s to configuration: %v", settings.HistoryRetention, err)
+		}
+	}
+	// Check if service settings for node membership history retention is ready
+	if !mgr.IsValue(settings.ServiceAdmin, settings.MembershipRetention) {
+		if err := mgr.NewIntegerValue(settings.ServiceAdmin, settings.MembershipRetention, int64(nodes.DefaultMembershipRetention)); err != nil {
+			return fmt.Errorf("Failed to add %s to configuration: %v", settings.MembershipRetention, err)
+		}
+	}
+	// Check if service settings for configuration lineage retention is ready
+	if !mgr.IsValue(settings.ServiceAdmin, settings.LineageRetention) {
+		if err := mgr.NewIntegerValue(settings.ServiceAdmin, settings.LineageRetention, int64(environments.DefaultLineageRetention)); err != nil {
+			return fmt.Errorf("Failed to add %s to configuration: %v", settings.LineageRetention, err)
+		}
+	}
+	// Check if service settings for data access log retention is ready
+	if !mgr.IsValue(settings.ServiceAdmin, settings.AccessRetention) {
+		if err := mgr.NewIntegerValue(settings.ServiceAdmin, settings.AccessRetention, int64(environments.DefaultAccessRetention)); err != nil {
+			return fmt.Errorf("Failed to add %s to configuration: %v", settings.AccessRetention, err)
+		}
+	}
+	// Check if service settings for the score to propose duplicate nodes is ready
+	if !mgr.IsValue(settings.ServiceAdmin, settings.DuplicateScore) {
+		if err := mgr.NewIntegerValue(settings.ServiceAdmin, settings.DuplicateScore, int64(nodes.DefaultMergeScore)); err != nil {
+			return fmt.Errorf("Failed to add %s to configuration: %v", settings.DuplicateScore, err)
+		}
+	}
+	// Check if service settings for near-identical hostnames of duplicate nodes is ready
+	if !mgr.IsValue(settings.ServiceAdmin, settings.DuplicateHostname) {
+		if err := mgr.NewIntegerValue(settings.ServiceAdmin, settings.DuplicateHostname, int64(nodes.DefaultMergeHostnameDistance)); err != nil {
+			return fmt.Errorf("Failed to add %s to configuration: %v", settings.DuplicateHostname, err)
+		}
+	}
+	// Check if service settings for the days to split merged nodes is ready
+	if !mgr.IsValue(settings.ServiceAdmin, settings.MergeSplitDays) {
+		if err := mgr.NewIntegerValue(settings.ServiceAdmin, settings.MergeSplitDays, int64(nodes.DefaultMergeSplitDays)); err != nil {
+			return fmt.Errorf("Failed to add %s to configuration: %v", settings.MergeSplitDays, err)
+		}
+	}
+	// Check if service settings for the days to archive queries is ready
+	if !mgr.IsValue(settings.ServiceAdmin, settings.QueryArchiveDays) {
+		if err := mgr.NewIntegerValue(settings.ServiceAdmin, settings.QueryArchiveDays, int64(queries.DefaultArchiveDays)); err != nil {
+			return fmt.Errorf("Failed to add %s to configuration: %v", settings.QueryArchiveDays, err)
+		}
+	}
+	// Check if service settings for archiving the executions of queries is ready
+	if !mgr.IsValue(settings.ServiceAdmin, settings.ArchiveExecutions) {
+		if err := mgr.NewBooleanValue(settings.ServiceAdmin, settings.ArchiveExecutions, false); err != nil {
+			return fmt.Errorf("Failed to add %s to configuration: %v", settings.ArchiveExecutions, err)
+		}
+	}
+	// Check if service settings for the hours to keep rehydrated queries is ready
+	if !mgr.IsValue(settings.ServiceAdmin, settings.RehydrateHours) {
+		if err := mgr.NewIntegerValue(settings.ServiceAdmin, settings.RehydrateHours, int64(queries.DefaultRehydrateHours)); err != nil {
+			return fmt.Errorf("Failed to add %s to configuration: %v", settings.RehydrateHours, err)
+		}
+	}
+	// Check if service settings for tag jobs batch size is ready
+	if !mgr.IsValue(settings.ServiceAdmin, settings.TagJobBatch) {
+		if err := mgr.NewIntegerValue(settings.ServiceAdmin, settings.TagJobBatch, int64(tags.DefaultTagJobBatch)); err != nil {
+			return fmt.Errorf("Failed to add %s to configuration: %v", settings.TagJobBatch, err)
+		}
+	}
+	// Check if service settings for tag jobs confirmation threshold is ready
+	if !mgr.IsValue(settings.ServiceAdmin, settings.TagJobConfirm) {
+		if err := mgr.NewIntegerValue(settings.ServiceAdmin, settings.TagJobConfirm, int64(tags.DefaultTagJobConfirm)); err != nil {
+			return fmt.Errorf("Failed to add %s to configuration: %v", settings.TagJobConfirm, err)
+		}
+	}
+	// Check if service settings for node attributes size is ready
+	if !mgr.IsValue(settings.ServiceAdmin, settings.AttributesMaxBytes) {
+		if err := mgr.NewIntegerValue(settings.ServiceAdmin, settings.AttributesMaxBytes, int64(nodes.DefaultAttributesMaxBytes)); err != nil {
+			return fmt.Errorf("Failed to add %s to configuration: %v", settings.AttributesMaxBytes, err)
+		}
+	}
+	// Check if service settings for trash retention is ready
+	if !mgr.IsValue(settings.ServiceAdmin, settings.TrashRetention) {
+		if err := mgr.NewIntegerValue(settings.ServiceAdmin, settings.TrashRetention, int64(backend.DefaultTrashDays)); err != nil {
+			return fmt.Errorf("Failed to add %s to configuration: %v", settings.TrashRetention, err)
+		}
+	}
+	// Check if service settings for conflicts in configurations are ready
+	if !mgr.IsValue(settings.ServiceAdmin, settings.ConfigConflicts) {
+		if err := mgr.NewStringValue(settings.ServiceAdmin, settings.ConfigConflicts, environments.ConflictsWarn); err != nil {
+			return fmt.Errorf("Failed to add %s to configuration: %v", settings.ConfigConflicts, err)
+		}
+	}
+	if !mgr.IsValue(settings.ServiceAdmin, settings.QueryBudget) {
+		if err := mgr.NewIntegerValue(settings.ServiceAdmin, settings.QueryBudget, environments.DefaultQueryBudget); err != nil {
+			return fmt.Errorf("Failed to add %s to configuration: %v", settings.QueryBudget, err)
+		}
+	}
+	// Check if service settings for node status tokens is ready
+	if !mgr.IsValue(settings.ServiceAdmin, settings.StatusTokens) {
+		if err := mgr.NewBooleanValue(settings.ServiceAdmin, settings.StatusTokens, false); err != nil {
+			return fmt.Errorf("Failed to add %s to settings: %v", settings.StatusTokens, err)
+		}
+	}
+	// Check if service settings for node status tokens lifetime is ready
+	if !mgr.IsValue(settings.ServiceAdmin, settings.StatusTokenHours) {
+		if err := mgr.NewIntegerValue(settings.ServiceAdmin, settings.StatusTokenHours, int64(nodes.DefaultStatusTokenHours)); err != nil {
+			return fmt.Errorf("Failed to add %s to configuration: %v", settings.StatusTokenHours, err)
+		}
+	}
+	// Check if service settings for login lockouts are ready
+	if !mgr.IsValue(settings.ServiceAdmin, settings.LoginMaxFailures) {
+		if err := mgr.NewIntegerValue(settings.ServiceAdmin, settings.LoginMaxFailures, users.DefaultLoginFailures); err != nil {
+			return fmt.Errorf("Failed to add %s to configuration: %v", settings.LoginMaxFailures, err)
+		}
+	}
+	if !mgr.IsValue(settings.ServiceAdmin, settings.LoginLockoutMins) {
+		if err := mgr.NewIntegerValue(settings.ServiceAdmin, settings.LoginLockoutMins, users.DefaultLoginLockoutMinutes); err != nil {
+			return fmt.Errorf("Failed to add %s to configuration: %v", settings.LoginLockoutMins, err)
+		}
+	}
+	if !mgr.IsValue(settings.ServiceAdmin, settings.LoginLockoutHook) {
+		if err := mgr.NewStringValue(settings.ServiceAdmin, settings.LoginLockoutHook, ""); err != nil {
+			return fmt.Errorf("Failed to add %s to configuration: %v", settings.LoginLockoutHook, err)
+		}
+	}
+	// Check if service settings for storage quota notifications is ready
+	if !mgr.IsValue(settings.ServiceAdmin, settings.StorageQuotaHook) {
+		if err := mgr.NewStringValue(settings.ServiceAdmin, settings.StorageQuotaHook, ""); err != nil {
+			return fmt.Errorf("Failed to add %s to configuration: %v", settings.StorageQuotaHook, err)
+		}
+	}
+	// Check if service settings for expired mutes notifications is ready
+	if !mgr.IsValue(settings.ServiceAdmin, settings.MuteExpiryHook) {
+		if err := mgr.NewStringValue(settings.ServiceAdmin, settings.MuteExpiryHook, ""); err != nil {
+			return fmt.Errorf("Failed to add %s to configuration: %v", settings.MuteExpiryHook, err)
+		}
+	}
+	// Check if service settings for the osqueryd check of configurations is ready, disabled by default
+	if !mgr.IsValue(settings.ServiceAdmin, settings.OsquerydCheckPath) {
+		if err := mgr.NewStringValue(settings.ServiceAdmin, settings.OsquerydCheckPath, ""); err != nil {
+			return fmt.Errorf("Failed to add %s to configuration: %v", settings.OsquerydCheckPath, err)
+		}
+	}
+	// Check if service settings for the listing of restricted queries is ready, hidden by default
+	if !mgr.IsValue(settings.ServiceAdmin, settings.RestrictedListing) {
+		if err := mgr.NewStringValue(settings.ServiceAdmin, settings.RestrictedListing, queries.ListingHide); err != nil {
+			return fmt.Errorf("Failed to add %s to configuration: %v", settings.RestrictedListing, err)
+		}
+	}
+	// Check if service settings for clock skew of nodes is ready
+	if !mgr.IsValue(settings.ServiceAdmin, settings.ClockSkewSeconds) {
+		if err := mgr.NewIntegerValue(settings.ServiceAdmin, settings.ClockSkewSeconds, nodes.DefaultClockSkew); err != nil {
+			return fmt.Errorf("Failed to add %s to configuration: %v", settings.ClockSkewSeconds, err)
+		}
+	}
+	// Check if service settings for the range of query expirations is ready
+	if !mgr.IsValue(settings.ServiceAdmin, settings.QueryExpirationMin) {
+		if err := mgr.NewIntegerValue(settings.ServiceAdmin, settings.QueryExpirationMin, queries.DefaultExpirationMinHours); err != nil {
+			return fmt.Errorf("Failed to add %s to configuration: %v", settings.QueryExpirationMin, err)
+		}
+	}
+	if !mgr.IsValue(settings.ServiceAdmin, settings.QueryExpirationMax) {
+		if err := mgr.NewIntegerValue(settings.ServiceAdmin, settings.QueryExpirationMax, queries.DefaultExpirationMaxHours); err != nil {
+			return fmt.Errorf("Failed to add %s to configuration: %v", settings.QueryExpirationMax, err)
+		}
+	}
+	// Check if service settings for display dashboard is ready
+	if !mgr.IsValue(settings.ServiceAdmin, settings.NodeDashboard) {
+		if err := mgr.NewBooleanValue(settings.ServiceAdmin, settings.NodeDashboard, false); err != nil {
+			return fmt.Errorf("Failed to add %s to settings: %v", settings.NodeDashboard, err)
+		}
+	}
+	// Write JSON config to settings
+	if err := mgr.SetAdminJSON(adminConfig); err != nil {
+		return fmt.Errorf("Failed to add JSON values to configuration: %v", err)
+	}
+	return nil
+}
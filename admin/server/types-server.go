@@ -1,4 +1,4 @@
-package main
+package server
 
 // JSONAdminUsers to keep all admin users for auth JSON
 type JSONAdminUsers struct {
@@ -1,4 +1,4 @@
-package main
+package server
 
 import (
 	"bytes"
@@ -120,3 +120,14 @@ func loadOsqueryTables(file string) ([]types.OsqueryTable, error) {
 	}
 	return tables, nil
 }
+
+// Helper to split a comma separated list of values, ignoring empty ones
+func splitValues(list string) []string {
+	var values []string
+	for _, v := range strings.Split(list, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
@@ -89,6 +89,11 @@ func CreateSessionManager(db *gorm.DB, name, sKey string) *SessionManager {
 	return st
 }
 
+// SetURLPrefix to scope the session cookie to the URL path prefix the service is served under
+func (sm *SessionManager) SetURLPrefix(prefix string) {
+	sm.Options.Path = utils.CookiePath(prefix)
+}
+
 // CheckAuth to verify if a session exists/is valid
 func (sm *SessionManager) CheckAuth(r *http.Request) (bool, UserSession) {
 	cookie, err := r.Cookie(sm.CookieName)
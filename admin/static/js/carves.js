@@ -6,9 +6,13 @@ function sendCarve(_url, _redir) {
   var _host_list = $("#target_hosts").val();
   var _repeat = $('#target_repeat').prop('checked') ? 1 : 0;
   var _path = $("#carve").val();
+  var _justification = $("#carve_justification").length ? $("#carve_justification").val() : "";
 
-  // Making sure targets are specified
-  if (_env_list.length === 0 && _platform_list.length === 0 && _uuid_list.length === 0 && _host_list.length === 0) {
+  var _window = targetWindow();
+  var _attributes = targetAttributes();
+
+  // Making sure targets are specified, a time window alone targets the whole environment
+  if (_env_list.length === 0 && _platform_list.length === 0 && _uuid_list.length === 0 && _host_list.length === 0 && !targetWindowSet(_window) && _attributes.length === 0) {
     $("#warningModalMessage").text("No targets have been specified");
     $("#warningModal").modal();
     return;
@@ -45,9 +49,34 @@ function sendCarve(_url, _redir) {
     uuid_list: _uuid_list,
     host_list: _host_list,
     path: _path,
-    repeat: _repeat
+    repeat: _repeat,
+    enrolled_after: _window.enrolled_after,
+    enrolled_before: _window.enrolled_before,
+    seen_after: _window.seen_after,
+    seen_before: _window.seen_before,
+    attribute_list: _attributes,
+    justification: _justification,
+    visibility: $("#carve_visibility").val(),
+    visibility_team: $("#carve_visibility").val() === "team" ? $("#carve_visibility_team").val() : ""
   };
-  sendPostRequest(data, _url, _redir, false);
+  confirmNotices(data, function (_data) {
+    sendLaunch(_data, _url, _redir);
+  });
+}
+
+function setCarveLimits() {
+  var _csrftoken = $("#csrftoken").val();
+  var _env = window.location.pathname.substring(urlPrefix.length).split('/')[2];
+  var _url = urlPrefix + '/carves/' + _env + '/limits';
+  var data = {
+    csrftoken: _csrftoken,
+    max_size: $("#limit_max_size").val(),
+    max_node_sessions: parseInt($("#limit_node_sessions").val() || "0", 10),
+    max_env_sessions: parseInt($("#limit_env_sessions").val() || "0", 10),
+    daily_size: $("#limit_daily_size").val(),
+    denied_paths: $("#limit_denied_paths").val(),
+  };
+  sendPostRequest(data, _url, window.location.pathname, false);
 }
 
 function clearCarve() {
@@ -99,10 +128,78 @@ function actionCarves(_action, _ids, _url, _redir) {
   sendPostRequest(data, _url, _redir, false);
 }
 
-function downloadCarve(_downloadUrl) {
+function downloadCarve(_downloadUrl, _decision, _verdict, _admin) {
+  var _reason = (_verdict === '') ? 'not scanned' : _verdict;
+  if (_decision === 'warn') {
+    $("#confirmModalMessage").text('This carve is ' + _reason + ' according to the scanner. Download anyway?');
+    $('#confirm_action').click(function () {
+      $('#confirmModal').modal('hide');
+      location.href = _downloadUrl;
+    });
+    $("#confirmModal").modal();
+    return;
+  }
+  if (_decision === 'block') {
+    if (!_admin) {
+      $("#warningModalMessage").text('Download blocked by the scan policy, this carve is ' + _reason);
+      $("#warningModal").modal();
+      return;
+    }
+    $("#confirmModalMessage").text('Download blocked by the scan policy, this carve is ' + _reason + '. Override the policy? The download will be recorded.');
+    $('#confirm_action').click(function () {
+      $('#confirmModal').modal('hide');
+      location.href = _downloadUrl + (_downloadUrl.indexOf('?') === -1 ? '?' : '&') + 'override=true';
+    });
+    $("#confirmModal").modal();
+    return;
+  }
   location.href = _downloadUrl;
 }
 
+function scanCarve(_ids, _url) {
+  actionCarves('scan', _ids, _url, window.location.pathname);
+}
+
+function indexCarve(_ids, _url) {
+  actionCarves('index', _ids, _url, window.location.pathname);
+}
+
 function refreshCarveDetails() {
   location.reload();
 }
+
+function createRecurringCarve() {
+  var _env = window.location.pathname.substring(urlPrefix.length).split('/')[2];
+  var _end = $("#recurring_end").val();
+  var data = {
+    csrftoken: $("#csrftoken").val(),
+    action: 'create',
+    case_id: $("#recurring_case").val(),
+    paths: $("#recurring_paths").val(),
+    uuids: $("#recurring_uuids").val(),
+    recurrence: $("#recurring_recurrence").val(),
+    end_at: _end ? new Date(_end).toISOString() : "",
+  };
+  $('#recurringModal').modal('hide');
+  sendPostRequest(data, urlPrefix + '/carves/' + _env + '/recurring', window.location.pathname, false);
+}
+
+function actionRecurringCarve(_action, _name) {
+  var _env = window.location.pathname.substring(urlPrefix.length).split('/')[2];
+  var data = {
+    csrftoken: $("#csrftoken").val(),
+    action: _action,
+    name: _name,
+  };
+  sendPostRequest(data, urlPrefix + '/carves/' + _env + '/recurring', window.location.pathname, false);
+}
+
+function confirmStopRecurringCarve(_name) {
+  var modal_message = 'Are you sure you want to stop ' + _name + '? No more carves will be launched, the carves already collected are kept.';
+  $("#confirmModalMessage").text(modal_message);
+  $('#confirm_action').click(function () {
+    $('#confirmModal').modal('hide');
+    actionRecurringCarve('stop', _name);
+  });
+  $("#confirmModal").modal();
+}
@@ -9,8 +9,83 @@ function saveB64Blob(editor_id, blob_key, header_id) {
     csrftoken: _csrftoken,
     [blob_key]: btoa(_blob),
   };
-  sendPostRequest(data, _url, _url, true);
-  $('#' + header_id).removeClass("bg-changed");
+  // Changes with an apply time are scheduled instead of saved now
+  var _applyAt = $("#schedule_apply_at").val();
+  if (_applyAt) {
+    data.apply_at = new Date(_applyAt).toISOString();
+    data.replace = $("#schedule_replace").is(':checked');
+  }
+  // Preview the active nodes receiving the change before saving it
+  sendPostRequest(data, _url + '/impact', "", false, function (_impact) {
+    $("#confirmModalMessage").empty().append(impactSummary(_impact));
+    if (_applyAt) {
+      $("#confirmModalMessage").append($('<p>').text('The changes are scheduled for ' + new Date(_applyAt).toString() + '.'));
+    }
+    // Notes are required by the policy of some categories of environments
+    var _note = $('<input class="form-control" type="text" placeholder="Change note">').val($("#schedule_note").val());
+    $("#confirmModalMessage").append(_note);
+    $('#confirm_action').off('click').click(function () {
+      $('#confirmModal').modal('hide');
+      data.note = _note.val();
+      sendPostRequest(data, _url, _url, true);
+      $('#' + header_id).removeClass("bg-changed");
+    });
+    $("#confirmModal").modal();
+  });
+}
+
+function impactSummary(_impact) {
+  var _summary = $('<span>');
+  if (!_impact.sections || _impact.sections.length === 0) {
+    _summary.append($('<p>').text('The configuration does not change. Save anyway?'));
+    return _summary;
+  }
+  _summary.append($('<p>').text('Changes to ' + _impact.sections.join(', ') + ' reach ' + _impact.affected_nodes + ' of ' + _impact.active_nodes + ' active nodes at their next fetch:'));
+  var _platforms = $('<ul>');
+  _impact.platforms.forEach(function (_p) {
+    _platforms.append($('<li>').text(_p.platform + ': ' + _p.nodes + ' nodes'));
+  });
+  _summary.append(_platforms);
+  if (_impact.added_executions !== 0) {
+    var _volume = $('<p>').text('Scheduled query executions per day: ' + (_impact.added_executions > 0 ? '+' : '') + _impact.added_executions);
+    if (_impact.heavy) {
+      _volume.addClass('text-danger font-weight-bold').append(' (heavy change)');
+    }
+    _summary.append(_volume);
+  }
+  _summary.append(verifySummary(_impact.verification));
+  _summary.append($('<p>').text('Save the changes?'));
+  return _summary;
+}
+
+function verifySummary(_report) {
+  var _verify = $('<span>');
+  if (!_report || _report.results.length === 0) {
+    return _verify;
+  }
+  var _versions = _report.results.map(function (_r) {
+    return _r.version + ' (' + _r.platform + ')';
+  });
+  if (_report.ok) {
+    _verify.append($('<p>').text('Flags and configuration verified for osquery ' + _versions.join(', ') + '.'));
+    return _verify;
+  }
+  _verify.append($('<p>').addClass('text-danger font-weight-bold').text('Flags and configuration not accepted by all the osquery versions of the nodes:'));
+  var _issues = $('<ul>').addClass('text-danger');
+  _report.results.forEach(function (_r) {
+    _r.issues.forEach(function (_i) {
+      if (_i.severity === 'error') {
+        _issues.append($('<li>').text('osquery ' + _r.version + ' (' + _r.platform + '): ' + _i.name + (_i.line ? ' at line ' + _i.line : '') + ' - ' + _i.message));
+      }
+    });
+  });
+  (_report.osqueryd || []).forEach(function (_c) {
+    _c.issues.forEach(function (_i) {
+      _issues.append($('<li>').text('osqueryd ' + _c.version + ' (' + _c.platform + '): ' + (_i.name ? _i.name + ' - ' : '') + _i.message));
+    });
+  });
+  _verify.append(_issues);
+  return _verify;
 }
 
 function saveConfiguration() {
@@ -43,7 +118,7 @@ function saveIntervals() {
   var _log = $("#logging_range").val();
   var _query = $("#query_range").val();
 
-  var _url = '/intervals/' + window.location.pathname.split('/').pop();
+  var _url = urlPrefix + '/intervals/' + window.location.pathname.split('/').pop();
 
   var data = {
     csrftoken: _csrftoken,
@@ -60,6 +135,162 @@ function changeIntervalValue(range_input, range_output) {
   $('#intervals_header').addClass("bg-changed");
 }
 
+function setProfile() {
+  var _csrftoken = $("#csrftoken").val();
+  var _url = urlPrefix + '/conf/' + window.location.pathname.split('/').pop() + '/profiles';
+  var data = {
+    csrftoken: _csrftoken,
+    action: 'set',
+    name: $("#profile_name").val(),
+    priority: parseInt($("#profile_priority").val()) || 0,
+    tag: $("#profile_tag").val(),
+    platform: $("#profile_platform").val(),
+    hardware_model: $("#profile_hardware").val(),
+    config_interval: parseInt($("#profile_config").val()) || 0,
+    log_interval: parseInt($("#profile_log").val()) || 0,
+    query_interval: parseInt($("#profile_query").val()) || 0,
+  };
+  sendPostRequest(data, _url, window.location.pathname, false);
+}
+
+function deleteProfile(_name) {
+  var _csrftoken = $("#csrftoken").val();
+  var _url = urlPrefix + '/conf/' + window.location.pathname.split('/').pop() + '/profiles';
+  var data = {
+    csrftoken: _csrftoken,
+    action: 'delete',
+    name: _name,
+  };
+  sendPostRequest(data, _url, window.location.pathname, false);
+}
+
+function changeDenyCandidate() {
+  var _selected = $("#deny_candidate option:selected");
+  $("#deny_pack").val(_selected.data('pack') || '');
+  $("#deny_query").val(_selected.data('query') || '');
+}
+
+function addDeny() {
+  var _csrftoken = $("#csrftoken").val();
+  var _url = urlPrefix + '/conf/' + window.location.pathname.split('/').pop() + '/denylist';
+  var data = {
+    csrftoken: _csrftoken,
+    action: 'add',
+    pack: $("#deny_pack").val(),
+    query: $("#deny_query").val(),
+    platform: $("#deny_platform").val(),
+    tag: $("#deny_tag").val(),
+    reason: $("#deny_reason").val(),
+    hours: parseInt($("#deny_hours").val()) || 0,
+  };
+  sendPostRequest(data, _url, window.location.pathname, false);
+}
+
+function confirmLiftDeny(_id, _target) {
+  var modal_message = 'Are you sure you want to lift the denylist entry for ' + _target + '? Nodes get it again with their next configuration.';
+  $("#confirmModalMessage").text(modal_message);
+  $('#confirm_action').click(function () {
+    $('#confirmModal').modal('hide');
+    var _url = urlPrefix + '/conf/' + window.location.pathname.split('/').pop() + '/denylist';
+    var data = {
+      csrftoken: $("#csrftoken").val(),
+      action: 'lift',
+      id: _id,
+    };
+    sendPostRequest(data, _url, window.location.pathname, false);
+  });
+  $("#confirmModal").modal();
+}
+
+function setQuickAction() {
+  var _csrftoken = $("#csrftoken").val();
+  var _url = urlPrefix + '/conf/' + window.location.pathname.split('/').pop() + '/quickactions';
+  var data = {
+    csrftoken: _csrftoken,
+    action: 'set',
+    name: $("#quick_name").val(),
+    label: $("#quick_label").val(),
+    icon: $("#quick_icon").val(),
+    type: $("#quick_type").val(),
+    template: $("#quick_template").val(),
+    variables: $("#quick_variables").val(),
+    path: $("#quick_path").val(),
+    tag: $("#quick_tag").val(),
+  };
+  sendPostRequest(data, _url, window.location.pathname, false);
+}
+
+function deleteQuickAction(_name) {
+  var _csrftoken = $("#csrftoken").val();
+  var _url = urlPrefix + '/conf/' + window.location.pathname.split('/').pop() + '/quickactions';
+  var data = {
+    csrftoken: _csrftoken,
+    action: 'delete',
+    name: _name,
+  };
+  sendPostRequest(data, _url, window.location.pathname, false);
+}
+
+function setStorageQuota() {
+  var _csrftoken = $("#csrftoken").val();
+  var _url = urlPrefix + '/conf/' + window.location.pathname.split('/').pop() + '/storage';
+  var _actions = [];
+  $(".quota-action:checked").each(function () {
+    _actions.push($(this).val());
+  });
+  var data = {
+    csrftoken: _csrftoken,
+    max_size: $("#quota_size").val(),
+    actions: _actions,
+  };
+  sendPostRequest(data, _url, window.location.pathname, false);
+}
+
+function setWindowsEvent(_channel, _enabled, _interval, _rows) {
+  var _csrftoken = $("#csrftoken").val();
+  var _url = urlPrefix + '/conf/' + window.location.pathname.split('/').pop() + '/windows-events';
+  var data = {
+    csrftoken: _csrftoken,
+    action: 'set',
+    channel: _channel,
+    enabled: _enabled,
+    interval: _interval,
+    max_rows: _rows,
+  };
+  sendPostRequest(data, _url, window.location.pathname, false);
+}
+
+function addWindowsEvent() {
+  setWindowsEvent(
+    $("#winevent_channel").val(),
+    $("#winevent_enabled").is(':checked'),
+    parseInt($("#winevent_interval").val()) || 0,
+    parseInt($("#winevent_rows").val()) || 0
+  );
+}
+
+function deleteWindowsEvent(_channel) {
+  var _csrftoken = $("#csrftoken").val();
+  var _url = urlPrefix + '/conf/' + window.location.pathname.split('/').pop() + '/windows-events';
+  var data = {
+    csrftoken: _csrftoken,
+    action: 'delete',
+    channel: _channel,
+  };
+  sendPostRequest(data, _url, window.location.pathname, false);
+}
+
+function importWindowsEvents() {
+  var _csrftoken = $("#csrftoken").val();
+  var _url = urlPrefix + '/conf/' + window.location.pathname.split('/').pop() + '/windows-events';
+  var data = {
+    csrftoken: _csrftoken,
+    action: 'import',
+    import: $("#winevent_import").val(),
+  };
+  sendPostRequest(data, _url, window.location.pathname, false);
+}
+
 function lineCharPosition(_pos) {
   var line = 0;
   var ttl = 0;
@@ -74,3 +305,85 @@ function lineCharPosition(_pos) {
   });
   return 'line ' + line;
 }
+
+function sendAPIKeyRequest(data) {
+  var _url = urlPrefix + '/conf/' + window.location.pathname.split('/').pop() + '/apikeys';
+  // Keys are only returned once, reload after the message is closed
+  sendPostRequest(data, _url, '', true, function () {
+    $('#successModal').on('hidden.bs.modal', function () {
+      window.location.reload();
+    });
+  });
+}
+
+function addAPIKey() {
+  var data = {
+    csrftoken: $("#csrftoken").val(),
+    action: 'add',
+    name: $("#apikey_name").val(),
+    scopes: $("#apikey_scopes").val() || [],
+    days: parseInt($("#apikey_days").val()) || 0,
+  };
+  // Node attributes can be written only in some namespaces
+  $("#apikey_namespaces").val().split(',').forEach(function (_ns) {
+    if (_ns.trim() !== '') {
+      data.scopes.push('attributes:write:' + _ns.trim().toLowerCase());
+    }
+  });
+  sendAPIKeyRequest(data);
+}
+
+function rotateAPIKey(_name) {
+  var data = {
+    csrftoken: $("#csrftoken").val(),
+    action: 'rotate',
+    name: _name,
+    days: parseInt($("#apikey_days").val()) || 0,
+    overlap: parseInt($("#apikey_overlap").val()) || 0,
+  };
+  sendAPIKeyRequest(data);
+}
+
+function revokeAPIKey(_keyid) {
+  var data = {
+    csrftoken: $("#csrftoken").val(),
+    action: 'revoke',
+    key_id: _keyid,
+  };
+  sendAPIKeyRequest(data);
+}
+
+function showVersionsDiff() {
+  var _env = window.location.pathname.substring(urlPrefix.length).split('/')[2];
+  window.location.href = urlPrefix + '/conf/' + _env + '/versions?from=' + $("#version_from").val() + '&to=' + $("#version_to").val();
+}
+
+function confirmCancelScheduled() {
+  var modal_message = 'Are you sure you want to cancel the scheduled change? It will not be applied.';
+  $("#confirmModalMessage").text(modal_message);
+  $('#confirm_action').click(function () {
+    $('#confirmModal').modal('hide');
+    var _env = window.location.pathname.substring(urlPrefix.length).split('/')[2];
+    var data = {
+      csrftoken: $("#csrftoken").val(),
+    };
+    sendPostRequest(data, urlPrefix + '/conf/' + _env + '/scheduled', urlPrefix + '/conf/' + _env, false);
+  });
+  $("#confirmModal").modal();
+}
+
+function confirmRollbackConfig(_version) {
+  var modal_message = 'Are you sure you want to rollback the configuration to version ' + _version + '? It will be saved as a new version.';
+  $("#confirmModalMessage").text(modal_message);
+  $('#confirm_action').click(function () {
+    $('#confirmModal').modal('hide');
+    var _env = window.location.pathname.substring(urlPrefix.length).split('/')[2];
+    var data = {
+      csrftoken: $("#csrftoken").val(),
+      version: _version,
+      note: $("#rollback_note").val(),
+    };
+    sendPostRequest(data, urlPrefix + '/conf/' + _env + '/rollback', urlPrefix + '/conf/' + _env, false);
+  });
+  $("#confirmModal").modal();
+}
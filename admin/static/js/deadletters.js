@@ -0,0 +1,61 @@
+function checkAllDeadLetters(_check) {
+  $(".dead-letter-check").prop('checked', $(_check).prop('checked'));
+}
+
+function selectedDeadLetters() {
+  var _ids = [];
+  $(".dead-letter-check:checked").each(function () {
+    _ids.push(parseInt($(this).val()));
+  });
+  return _ids;
+}
+
+function sendDeadLetters(_action, _ids, _backend, _reason) {
+  var _csrftoken = $("#csrftoken").val();
+  var _url = window.location.pathname;
+  var data = {
+    csrftoken: _csrftoken,
+    action: _action,
+    ids: _ids,
+    backend: _backend,
+    reason: _reason,
+    target: $("#replay_target").val(),
+  };
+  sendPostRequest(data, _url, _url, false);
+}
+
+function replaySelected() {
+  var _ids = selectedDeadLetters();
+  if (_ids.length === 0) {
+    return;
+  }
+  sendDeadLetters('replay', _ids, '', '');
+}
+
+function replayReason(_backend, _reason) {
+  sendDeadLetters('replay', [], _backend, _reason);
+}
+
+function confirmPurgeSelected() {
+  var _ids = selectedDeadLetters();
+  if (_ids.length === 0) {
+    return;
+  }
+  var modal_message = 'Are you sure you want to purge ' + _ids.length + ' dead letters?';
+  $("#confirmModalMessage").text(modal_message);
+  $('#confirm_action').click(function () {
+    $('#confirmModal').modal('hide');
+    sendDeadLetters('purge', _ids, '', '');
+  });
+  $("#confirmModal").modal();
+}
+
+function confirmPurgeReason(_backend, _reason) {
+  var modal_message = 'Are you sure you want to purge all dead letters for ' + _backend + ' (' + _reason + ')?';
+  $("#confirmModalMessage").text(modal_message);
+  $('#confirm_action').click(function () {
+    $('#confirmModal').modal('hide');
+    sendDeadLetters('purge', [], _backend, _reason);
+  });
+  $("#confirmModal").modal();
+}
@@ -1,6 +1,6 @@
 function genericLinkAction(_type, _action) {
   var _csrftoken = $("#csrftoken").val();
-  var _url = '/expiration/' + window.location.pathname.split('/').pop();
+  var _url = urlPrefix + '/expiration/' + window.location.pathname.split('/').pop();
   var data = {
     csrftoken: _csrftoken,
     type: _type,
@@ -62,3 +62,28 @@ function uploadCertificate() {
   };
   sendPostRequest(data, _url, window.location.pathname, false);
 }
+
+function setEnrollField() {
+  var _csrftoken = $("#csrftoken").val();
+  var _url = urlPrefix + '/enroll/' + window.location.pathname.split('/').pop() + '/fields';
+  var data = {
+    csrftoken: _csrftoken,
+    action: 'set',
+    name: $("#enroll_field_name").val(),
+    description: $("#enroll_field_description").val(),
+    regex: $("#enroll_field_regex").val(),
+    required: $("#enroll_field_required").is(':checked'),
+  };
+  sendPostRequest(data, _url, window.location.pathname, false);
+}
+
+function deleteEnrollField(_name) {
+  var _csrftoken = $("#csrftoken").val();
+  var _url = urlPrefix + '/enroll/' + window.location.pathname.split('/').pop() + '/fields';
+  var data = {
+    csrftoken: _csrftoken,
+    action: 'delete',
+    name: _name,
+  };
+  sendPostRequest(data, _url, window.location.pathname, false);
+}
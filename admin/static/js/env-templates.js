@@ -0,0 +1,109 @@
+function createEnvTemplate() {
+  $("#createEnvTemplateModal").modal();
+}
+
+function confirmCreateEnvTemplate() {
+  var _csrftoken = $("#csrftoken").val();
+
+  var _url = urlPrefix + '/environments/templates';
+
+  var data = {
+    csrftoken: _csrftoken,
+    action: 'create',
+    name: $("#template_name").val(),
+    description: $("#template_description").val(),
+    environment: $("#template_env").val(),
+  };
+  sendPostRequest(data, _url, _url, false);
+}
+
+function confirmDeleteEnvTemplate(_name) {
+  var modal_message = 'Are you sure you want to delete the template ' + _name + '? Environments created from it are not changed.';
+  $("#confirmModalMessage").text(modal_message);
+  $('#confirm_action').click(function () {
+    $('#confirmModal').modal('hide');
+    deleteEnvTemplate(_name);
+  });
+  $("#confirmModal").modal();
+}
+
+function deleteEnvTemplate(_name) {
+  var _csrftoken = $("#csrftoken").val();
+
+  var _url = urlPrefix + '/environments/templates';
+
+  var data = {
+    csrftoken: _csrftoken,
+    action: 'delete',
+    name: _name,
+  };
+  sendPostRequest(data, _url, _url, false);
+}
+
+function saveEnvTemplate(_name) {
+  var _csrftoken = $("#csrftoken").val();
+
+  var _url = urlPrefix + '/environments/templates';
+
+  var data = {
+    csrftoken: _csrftoken,
+    action: 'update',
+    name: _name,
+    description: $("#tpl_description").val(),
+    note: $("#tpl_note").val(),
+    config: {
+      Hostname: $("#tpl_hostname").val(),
+      Type: $("#tpl_type").val(),
+      Icon: $("#tpl_icon").val(),
+      DebugHTTP: $("#tpl_debug_http").is(':checked'),
+      Options: $("#tpl_options").val(),
+      Schedule: $("#tpl_schedule").val(),
+      Packs: $("#tpl_packs").val(),
+      Decorators: $("#tpl_decorators").val(),
+      ATC: $("#tpl_atc").val(),
+      Flags: $("#tpl_flags").val(),
+      ConfigTLS: $("#tpl_config_tls").is(':checked'),
+      ConfigInterval: parseInt($("#tpl_config_interval").val(), 10) || 0,
+      LoggingTLS: $("#tpl_logging_tls").is(':checked'),
+      LogInterval: parseInt($("#tpl_log_interval").val(), 10) || 0,
+      QueryTLS: $("#tpl_query_tls").is(':checked'),
+      QueryInterval: parseInt($("#tpl_query_interval").val(), 10) || 0,
+      CarvesTLS: $("#tpl_carves_tls").is(':checked'),
+      CarveScanPolicy: $("#tpl_carve_scan").val(),
+      StormThreshold: parseInt($("#tpl_storm").val(), 10) || 0,
+      Locale: $("#tpl_locale").val(),
+      AllowedPlatforms: $("#tpl_platforms").val(),
+    },
+  };
+  sendPostRequest(data, _url, window.location.pathname, false);
+}
+
+function confirmPropagateEnvTemplate(_name) {
+  var _envs = $(".propagate-env:checked").map(function () {
+    return $(this).val();
+  }).get();
+  if (_envs.length === 0) {
+    return;
+  }
+  var modal_message = 'Are you sure you want to apply the template ' + _name + ' to ' + _envs.join(', ') + '? Their configuration will be replaced with the changes shown.';
+  $("#confirmModalMessage").text(modal_message);
+  $('#confirm_action').click(function () {
+    $('#confirmModal').modal('hide');
+    propagateEnvTemplate(_name, _envs);
+  });
+  $("#confirmModal").modal();
+}
+
+function propagateEnvTemplate(_name, _envs) {
+  var _csrftoken = $("#csrftoken").val();
+
+  var _url = urlPrefix + '/environments/templates';
+
+  var data = {
+    csrftoken: _csrftoken,
+    action: 'propagate',
+    name: _name,
+    environments: _envs,
+  };
+  sendPostRequest(data, _url, window.location.pathname, false);
+}
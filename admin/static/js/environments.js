@@ -11,6 +11,8 @@ function confirmCreateEnvironment() {
   var _type = $("#environment_type").val();
   var _hostname = $("#environment_host").val();
   var _icon = $("#environment_icon").val();
+  var _template = $("#environment_template").val();
+  var _category = $("#environment_category").val();
 
   var data = {
     csrftoken: _csrftoken,
@@ -19,6 +21,8 @@ function confirmCreateEnvironment() {
     type: _type,
     hostname: _hostname,
     icon: _icon,
+    template: _template,
+    category: _category,
   };
   sendPostRequest(data, _url, _url, false);
 }
@@ -60,3 +64,262 @@ function changeDebugHTTP(_env) {
   };
   sendPostRequest(data, _url, '', false);
 }
+
+function changeCarveScan(_env) {
+  var _csrftoken = $("#csrftoken").val();
+  var _value = $("#" + _env + "_carve_scan").val();
+
+  var _url = window.location.pathname;
+
+  var data = {
+    csrftoken: _csrftoken,
+    action: 'carve_scan',
+    carvescan: _value,
+    name: _env,
+  };
+  sendPostRequest(data, _url, '', false);
+}
+
+function changeIdentityPolicy(_env) {
+  var _csrftoken = $("#csrftoken").val();
+  var _value = $("#" + _env + "_identity").val();
+
+  var _url = window.location.pathname;
+
+  var data = {
+    csrftoken: _csrftoken,
+    action: 'identity',
+    identity: _value,
+    name: _env,
+  };
+  sendPostRequest(data, _url, '', false);
+}
+
+function changeStormThreshold(_env) {
+  var _csrftoken = $("#csrftoken").val();
+  var _value = parseInt($("#" + _env + "_storm").val(), 10);
+  if (isNaN(_value) || _value < 0) {
+    _value = 0;
+  }
+
+  var _url = window.location.pathname;
+
+  var data = {
+    csrftoken: _csrftoken,
+    action: 'storm',
+    storm: _value,
+    name: _env,
+  };
+  sendPostRequest(data, _url, '', false);
+}
+
+function changeQueryExpiration(_env) {
+  var _csrftoken = $("#csrftoken").val();
+  var _value = parseInt($("#" + _env + "_query_expiration").val(), 10);
+  if (isNaN(_value) || _value < 0) {
+    _value = 0;
+  }
+
+  var _url = window.location.pathname;
+
+  var data = {
+    csrftoken: _csrftoken,
+    action: 'query_expiration',
+    query_expiration: _value,
+    name: _env,
+  };
+  sendPostRequest(data, _url, '', false);
+}
+
+function changeCategory(_env) {
+  var _csrftoken = $("#csrftoken").val();
+  var _url = window.location.pathname;
+
+  var data = {
+    csrftoken: _csrftoken,
+    action: 'category',
+    category: $("#" + _env + "_category").val(),
+    name: _env,
+  };
+  sendPostRequest(data, _url, _url, false);
+}
+
+function changeCategoryPolicy(_category) {
+  var _csrftoken = $("#csrftoken").val();
+  var _targets = parseInt($("#" + _category + "_confirm_targets").val(), 10);
+  if (isNaN(_targets) || _targets < 0) {
+    _targets = 0;
+  }
+  var _expiration = parseInt($("#" + _category + "_policy_expiration").val(), 10);
+  if (isNaN(_expiration) || _expiration < 0) {
+    _expiration = 0;
+  }
+
+  var _url = window.location.pathname;
+
+  var data = {
+    csrftoken: _csrftoken,
+    action: 'category_policy',
+    category: _category,
+    confirm_targets: _targets,
+    require_note: $("#" + _category + "_require_note").is(':checked'),
+    query_expiration: _expiration,
+  };
+  sendPostRequest(data, _url, '', false);
+}
+
+function changeEnrollLoops(_env) {
+  var _csrftoken = $("#csrftoken").val();
+  var _limit = parseInt($("#" + _env + "_enroll_loop_limit").val(), 10);
+  if (isNaN(_limit) || _limit < 0) {
+    _limit = 0;
+  }
+  var _quiet = parseInt($("#" + _env + "_enroll_loop_quiet").val(), 10);
+  if (isNaN(_quiet) || _quiet < 0) {
+    _quiet = 0;
+  }
+
+  var _url = window.location.pathname;
+
+  var data = {
+    csrftoken: _csrftoken,
+    action: 'enroll_loops',
+    enroll_loop_limit: _limit,
+    enroll_loop_quiet: _quiet,
+    enroll_backoff: $("#" + _env + "_enroll_backoff").is(':checked'),
+    name: _env,
+  };
+  sendPostRequest(data, _url, '', false);
+}
+
+function changeNaming(_env) {
+  var _csrftoken = $("#csrftoken").val();
+
+  var _url = window.location.pathname;
+
+  var data = {
+    csrftoken: _csrftoken,
+    action: 'naming',
+    naming_domains: $("#" + _env + "_naming_domains").val(),
+    naming_lowercase: $("#" + _env + "_naming_lowercase").is(':checked'),
+    naming_prefer_fqdn: $("#" + _env + "_naming_fqdn").is(':checked'),
+    name: _env,
+  };
+  sendPostRequest(data, _url, '', false, function (data) {
+    if (data.report.changed > 0 || Object.keys(data.report.collisions).length > 0) {
+      showNamingReport(_env, data.report);
+    }
+  });
+}
+
+// Show the names of nodes changed by the naming policy, with the option to normalize them when it is a dry run
+function showNamingReport(_env, _report) {
+  var _collisions = Object.keys(_report.collisions);
+  $("#naming_message").text(_report.changed + ' of ' + _report.nodes + ' names of nodes would change.');
+  $("#naming_changes").empty();
+  for (var i = 0; i < _report.changes.length; i++) {
+    var _c = _report.changes[i];
+    var _row = $('<tr>');
+    _row.append($('<td>').append($('<code>').text(_c.uuid)));
+    _row.append($('<td>').text(_c.hostname + ' / ' + _c.localname));
+    _row.append($('<td>').text(_c.from));
+    _row.append($('<td>').text(_c.to));
+    $("#naming_changes").append(_row);
+  }
+  if (_collisions.length > 0) {
+    var _list = [];
+    for (var j = 0; j < _collisions.length; j++) {
+      _list.push(_collisions[j] + ' (' + _report.collisions[_collisions[j]].length + ' nodes)');
+    }
+    $("#naming_collisions").text('Names shared by more than one node, they are flagged and not merged: ' + _list.join(', ')).show();
+  } else {
+    $("#naming_collisions").hide();
+  }
+  $("#naming_apply").toggle(_report.changed > 0);
+  $('#naming_apply').off('click').click(function () {
+    normalizeNames(_env, false);
+  });
+  $("#namingModal").modal();
+}
+
+function normalizeNames(_env, _dryrun) {
+  var _csrftoken = $("#csrftoken").val();
+
+  var _url = window.location.pathname;
+
+  var data = {
+    csrftoken: _csrftoken,
+    action: 'normalize',
+    dry_run: _dryrun,
+    name: _env,
+  };
+  if (!_dryrun) {
+    sendPostRequest(data, _url, '', true);
+    return;
+  }
+  sendPostRequest(data, _url, '', false, function (data) {
+    showNamingReport(_env, data.report);
+  });
+}
+
+function changePseudonyms(_env) {
+  var _csrftoken = $("#csrftoken").val();
+  var _columns = $("#" + _env + "_pseudonym_columns").val();
+  var _tables = $("#" + _env + "_pseudonym_tables").val();
+
+  var _url = window.location.pathname;
+
+  var data = {
+    csrftoken: _csrftoken,
+    action: 'pseudonyms',
+    pseudonym_columns: _columns,
+    pseudonym_tables: _tables,
+    name: _env,
+  };
+  sendPostRequest(data, _url, '', false);
+}
+
+function changeLocale(_env) {
+  var _csrftoken = $("#csrftoken").val();
+  var _value = $("#" + _env + "_locale").val();
+
+  var _url = window.location.pathname;
+
+  var data = {
+    csrftoken: _csrftoken,
+    action: 'locale',
+    locale: _value,
+    name: _env,
+  };
+  sendPostRequest(data, _url, '', false);
+}
+
+function changeOsqueryPin(_env) {
+  var _csrftoken = $("#csrftoken").val();
+
+  var _url = window.location.pathname;
+
+  var data = {
+    csrftoken: _csrftoken,
+    action: 'osquery',
+    osquery_pin: $("#" + _env + "_osquery_pin").val(),
+    osquery_pins: $("#" + _env + "_osquery_pins").val(),
+    name: _env,
+  };
+  sendPostRequest(data, _url, '', false);
+}
+
+function changePlatforms(_env) {
+  var _csrftoken = $("#csrftoken").val();
+  var _value = $("#" + _env + "_platforms").val();
+
+  var _url = window.location.pathname;
+
+  var data = {
+    csrftoken: _csrftoken,
+    action: 'platforms',
+    platforms: _value,
+    name: _env,
+  };
+  sendPostRequest(data, _url, '', false);
+}
@@ -16,6 +16,9 @@ function sendGetRequest(req_url, _modal, _callback) {
       }
     },
     error: function (jqXhr, textStatus, errorThrown) {
+      if (_onError && _onError(jqXhr)) {
+        return;
+      }
       var _clientmsg = 'Client: ' + errorThrown;
       var _serverJSON = $.parseJSON(jqXhr.responseText);
       var _servermsg = 'Server: ' + _serverJSON.message;
@@ -27,7 +30,7 @@ function sendGetRequest(req_url, _modal, _callback) {
   });
 }
 
-function sendPostRequest(req_data, req_url, _redir, _modal, _callback) {
+function sendPostRequest(req_data, req_url, _redir, _modal, _callback, _onError) {
   $.ajax({
     url: req_url,
     dataType: 'json',
@@ -60,3 +63,62 @@ function sendPostRequest(req_data, req_url, _redir, _modal, _callback) {
     }
   });
 }
+
+// Offer to undo a delete that moved items to the trash, the page reloads when the modal is closed
+function undoDelete(_kind, _names, _env, _redir) {
+  var _restored = 0;
+  $("#undoModalMessage").text(_names.join(', ') + ' moved to the trash.');
+  $('#undo_action').off('click').click(function () {
+    $('#undo_action').prop('disabled', true);
+    for (var i = 0; i < _names.length; i++) {
+      var data = {
+        csrftoken: $("#csrftoken").val(),
+        action: 'restore',
+        kind: _kind,
+        name: _names[i],
+        environment: _env,
+      };
+      sendPostRequest(data, urlPrefix + '/trash', '', false, function () {
+        _restored++;
+        if (_restored === _names.length) {
+          $('#undoModal').modal('hide');
+        }
+      });
+    }
+  });
+  $('#undoModal').off('hidden.bs.modal').on('hidden.bs.modal', function () {
+    window.location.replace(_redir);
+  });
+  $('#undo_action').prop('disabled', false);
+  $("#undoModal").modal();
+}
+
+// Bounds of the time window to target nodes, as RFC3339 values or empty if not set
+function targetWindow() {
+  var _window = {};
+  var _bounds = ["enrolled_after", "enrolled_before", "seen_after", "seen_before"];
+  for (var i = 0; i < _bounds.length; i++) {
+    var _value = $("#target_" + _bounds[i]).val();
+    _window[_bounds[i]] = _value ? new Date(_value).toISOString() : "";
+  }
+  return _window;
+}
+
+// Custom attributes filters to target nodes, separated by commas
+function targetAttributes() {
+  var _attributes = [];
+  var _value = $("#target_attributes").val();
+  if (_value) {
+    _value.split(",").forEach(function (_filter) {
+      if (_filter.trim() !== "") {
+        _attributes.push(_filter.trim());
+      }
+    });
+  }
+  return _attributes;
+}
+
+// Check if any bound of the time window to target nodes is set
+function targetWindowSet(_window) {
+  return _window.enrolled_after !== "" || _window.enrolled_before !== "" || _window.seen_after !== "" || _window.seen_before !== "";
+}
@@ -0,0 +1,22 @@
+function editGroupLimits(_name, _perNode, _total) {
+  $("#group_name").val(_name);
+  $("#group_per_node").val(_perNode);
+  $("#group_total").val(_total);
+  $("#group_per_node").focus();
+}
+
+function setGroupLimits(_url) {
+  var _name = $("#group_name").val();
+  if (_name === "") {
+    $("#warningModalMessage").text("Group can not be empty");
+    $("#warningModal").modal();
+    return;
+  }
+  var data = {
+    csrftoken: $("#csrftoken").val(),
+    name: _name,
+    max_per_node: parseInt($("#group_per_node").val(), 10) || 0,
+    max_total: parseInt($("#group_total").val(), 10) || 0,
+  };
+  sendPostRequest(data, _url, window.location.pathname, false);
+}
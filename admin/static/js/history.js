@@ -0,0 +1,56 @@
+// Draw the node count history of an environment as a SVG line chart in the given element
+function drawHistory(_element, _history, _width, _height) {
+  var _svgNS = 'http://www.w3.org/2000/svg';
+  var _svg = document.createElementNS(_svgNS, 'svg');
+  _svg.setAttribute('width', '100%');
+  _svg.setAttribute('height', _height);
+  _svg.setAttribute('viewBox', '0 0 ' + _width + ' ' + _height);
+  _svg.setAttribute('preserveAspectRatio', 'none');
+  $(_element).empty();
+  if (!_history || _history.length === 0) {
+    $(_element).text('No history yet');
+    return;
+  }
+  var _max = 1;
+  for (var i = 0; i < _history.length; i++) {
+    _max = Math.max(_max, _history[i].count);
+  }
+  var _step = _history.length > 1 ? _width / (_history.length - 1) : 0;
+  var _points = [];
+  for (var j = 0; j < _history.length; j++) {
+    var _x = _history.length > 1 ? j * _step : _width / 2;
+    var _y = _height - 2 - (_history[j].count / _max) * (_height - 4);
+    _points.push(_x.toFixed(1) + ',' + _y.toFixed(1));
+  }
+  var _line = document.createElementNS(_svgNS, 'polyline');
+  _line.setAttribute('points', _points.join(' '));
+  _line.setAttribute('fill', 'none');
+  _line.setAttribute('stroke', '#20a8d8');
+  _line.setAttribute('stroke-width', '2');
+  _line.setAttribute('vector-effect', 'non-scaling-stroke');
+  var _title = document.createElementNS(_svgNS, 'title');
+  var _first = _history[0];
+  var _last = _history[_history.length - 1];
+  _title.textContent = _first.day.substring(0, 10) + ': ' + _first.count + ' - ' + _last.day.substring(0, 10) + ': ' + _last.count;
+  _svg.appendChild(_title);
+  _svg.appendChild(_line);
+  $(_element).append(_svg);
+}
+
+// Retrieve the node count history of an environment and draw it
+function historyRefresh(_env, _metric, _from, _element, _width, _height) {
+  $.ajax({
+    url: urlPrefix + '/json/history/' + _env + '?metric=' + encodeURIComponent(_metric) + '&from=' + encodeURIComponent(_from),
+    dataType: 'json',
+    type: 'GET',
+    contentType: 'application/json',
+    success: function (data, textStatus, jQxhr) {
+      drawHistory(_element, data, _width, _height);
+    },
+    error: function (jqXhr, textStatus, errorThrown) {
+      var _clientmsg = 'Client: ' + errorThrown;
+      console.log('Error getting history...');
+      console.log(_clientmsg);
+    }
+  });
+}
@@ -2,7 +2,7 @@ function sendLogin() {
   var _user = $("#login_user").val();
   var _password = $("#login_password").val();
 
-  var _url = '/login';
+  var _url = urlPrefix + '/login';
   var data = {
       username: _user,
       password: _password
@@ -15,11 +15,11 @@ function sendLogin() {
 function sendLogout() {
   var _csrf = $("#csrftoken").val();
 
-  var _url = '/logout';
+  var _url = urlPrefix + '/logout';
   var data = {
     csrftoken: _csrf
   };
-  sendPostRequest(data, _url, '/login', false);
+  sendPostRequest(data, _url, urlPrefix + '/login', false);
 }
 
 $("#login_password").keyup(function(event) {
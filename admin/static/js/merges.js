@@ -0,0 +1,42 @@
+function reviewMerge(_id, _action) {
+  var _csrftoken = $("#csrftoken").val();
+  var _url = window.location.pathname;
+  var data = {
+    csrftoken: _csrftoken,
+    action: _action,
+    id: _id,
+  };
+  sendPostRequest(data, _url, window.location.pathname + window.location.search, false);
+}
+
+function confirmReviewMerge(_id, _action) {
+  var _msg = 'Are you sure you want to merge the duplicate into the canonical node? The duplicate is archived.';
+  if (_action === 'split') {
+    _msg = 'Are you sure you want to split this merge? The duplicate node is restored with its records.';
+  }
+  $("#confirmModalMessage").text(_msg);
+  $('#confirm_action').click(function () {
+    $('#confirmModal').modal('hide');
+    reviewMerge(_id, _action);
+  });
+  $("#confirmModal").modal();
+}
+
+function showMergeNodes() {
+  $("#merge_into").val('');
+  $("#merge_from").val('');
+  $("#mergeNodesModal").modal();
+}
+
+function mergeNodes() {
+  var _csrftoken = $("#csrftoken").val();
+  var _url = window.location.pathname;
+  var data = {
+    csrftoken: _csrftoken,
+    action: 'merge',
+    into: $("#merge_into").val(),
+    from: $("#merge_from").val(),
+  };
+  $("#mergeNodesModal").modal('hide');
+  sendPostRequest(data, _url, window.location.pathname + '?state=all', false);
+}
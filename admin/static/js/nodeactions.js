@@ -14,7 +14,7 @@ function confirmRemoveNodes(_uuids) {
 function removeNodes(_uuids) {
   var _csrftoken = $("#csrftoken").val();
 
-  var _url = '/node/actions';
+  var _url = urlPrefix + '/node/actions';
   var data = {
     csrftoken: _csrftoken,
     uuids: _uuids,
@@ -23,8 +23,57 @@ function removeNodes(_uuids) {
   sendPostRequest(data, _url, '/', true);
 }
 
+function confirmDecommissionNodes(_uuids) {
+  var modal_message = 'Are you sure you want to decommission ' + _uuids.length + ' node(s)? osquery will be removed and the node(s) archived once they stop contacting.';
+  if (_uuids.length === 1) {
+    modal_message = 'Are you sure you want to decommission this node? osquery will be removed and the node archived once it stops contacting.';
+  }
+  $("#confirmModalMessage").text(modal_message);
+  $('#confirm_action').click(function () {
+    $('#confirmModal').modal('hide');
+    decommissionNodes(_uuids);
+  });
+  $("#confirmModal").modal();
+}
+
+function decommissionNodes(_uuids) {
+  var _csrftoken = $("#csrftoken").val();
+
+  var _url = urlPrefix + '/node/actions';
+  var data = {
+    csrftoken: _csrftoken,
+    uuids: _uuids,
+    action: 'decommission'
+  };
+  sendPostRequest(data, _url, window.location.pathname, true);
+}
+
+function showPauseNodes(_uuids) {
+  $("#pause_reason").val('');
+  $("#pause_expires").val('');
+  $('#pause_action').off('click').click(function () {
+    $('#pauseModal').modal('hide');
+    pauseNodes(_uuids, 'pause');
+  });
+  $("#pauseModal").modal();
+}
+
+function pauseNodes(_uuids, _action) {
+  var _csrftoken = $("#csrftoken").val();
+
+  var _url = urlPrefix + '/node/actions';
+  var data = {
+    csrftoken: _csrftoken,
+    uuids: _uuids,
+    action: _action,
+    reason: $("#pause_reason").val(),
+    expires: $("#pause_expires").val()
+  };
+  sendPostRequest(data, _url, window.location.pathname, true);
+}
+
 function nodesView(environment) {
-  window.location.href = '/environment/' + environment + '/active';
+  window.location.href = urlPrefix + '/environment/' + environment + '/active';
 }
 
 function refreshCurrentNode() {
@@ -89,7 +138,22 @@ function changeBackValue(table_id, range_input, range_output) {
   table.ajax.url(_url.split('seconds=')[0] + 'seconds=' + (range_output.value * 3600));
 }
 
-function tagNodes(_uuids) {
+function filterResultAction(table_id, action) {
+  var table = $('#' + table_id).DataTable();
+  table.column(2).search(action ? '^' + action + '$' : '', true, false).draw();
+}
+
+function resultActionBadge(action) {
+  if (action === 'added') {
+    return 'badge-success';
+  }
+  if (action === 'removed') {
+    return 'badge-danger';
+  }
+  return 'badge-info';
+}
+
+function tagNodes(_uuids, _confirm) {
   var _csrftoken = $("#csrftoken").val();
   var _addtags = [];
   $('#add_tags option').each(function () {
@@ -99,14 +163,56 @@ function tagNodes(_uuids) {
   $('#remove_tags option').each(function () {
     _removetags.push($(this).val());
   });
-  var _url = '/tags/nodes';
+  var _url = urlPrefix + '/tags/nodes';
   var data = {
     csrftoken: _csrftoken,
     uuids: _uuids,
     tagsadd: _addtags,
     tagsremove: _removetags,
+    confirm: _confirm === true,
+  };
+  sendPostRequest(data, _url, '', false, function (data) {
+    if (data.confirm) {
+      $("#confirmModalMessage").text('Are you sure you want to tag ' + data.total + ' nodes?');
+      $('#confirm_action').off('click').click(function () {
+        $('#confirmModal').modal('hide');
+        tagNodes(_uuids, true);
+      });
+      $("#confirmModal").modal();
+      return;
+    }
+    showTagJob(data.job);
+  });
+}
+
+// Poll the progress of a tag job until it is finished
+function showTagJob(_job) {
+  $("#tagJobProgress").css('width', '0%').attr('aria-valuenow', 0).text('');
+  $("#tagJobMessage").text('');
+  $("#tagJobModal").modal();
+  var _url = urlPrefix + '/json/tags/jobs/' + _job;
+  var _poll = function () {
+    $.getJSON(_url, function (job) {
+      $("#tagJobProgress").css('width', job.progress + '%').attr('aria-valuenow', job.progress).text(job.processed + '/' + job.total);
+      var _counts = job.added + ' added, ' + job.removed + ' removed, ' + job.already_present + ' already present';
+      if (job.conflicts > 0) {
+        _counts += ', ' + job.conflicts + ' conflicts with other tag jobs';
+      }
+      if (job.status === 'failed') {
+        $("#tagJobMessage").text('Failed: ' + job.error + ' - ' + _counts);
+        return;
+      }
+      $("#tagJobMessage").text(_counts);
+      if (job.status === 'completed') {
+        $('#tagJobModal').on('hidden.bs.modal', function () {
+          window.location.reload();
+        });
+        return;
+      }
+      setTimeout(_poll, 2000);
+    });
   };
-  sendPostRequest(data, _url, window.location, true);
+  _poll();
 }
 
 function showTagNodes(_uuids) {
@@ -116,3 +222,133 @@ function showTagNodes(_uuids) {
   });
   $("#tagModal").modal();
 }
+
+function showScheduleOverride() {
+  $("#overrideModal").modal();
+}
+
+function setScheduleOverride(_uuid) {
+  var _csrftoken = $("#csrftoken").val();
+  var _url = urlPrefix + '/node/schedule-override';
+  var data = {
+    csrftoken: _csrftoken,
+    action: 'set',
+    uuid: _uuid,
+    query: $("#override_query").val(),
+    interval: parseInt($("#override_interval").val()),
+    hours: parseInt($("#override_hours").val()),
+  };
+  sendPostRequest(data, _url, window.location.pathname, false);
+}
+
+function showStatusToken() {
+  $("#status_token_url").val('');
+  $("#status_token_expiration").text('');
+  $("#statusTokenModal").modal();
+}
+
+function generateStatusToken(_uuid) {
+  var _csrftoken = $("#csrftoken").val();
+  var _url = urlPrefix + '/node/status-token';
+  var data = {
+    csrftoken: _csrftoken,
+    uuid: _uuid,
+    hours: parseInt($("#status_token_hours").val()),
+  };
+  sendPostRequest(data, _url, '', false, function (data) {
+    $("#status_token_url").val(window.location.origin + data.path);
+    $("#status_token_expiration").text('Expires ' + data.expiration);
+    $("#status_token_url").select();
+  });
+}
+
+function confirmReenrollNode(_uuid, _redirect) {
+  $("#confirmModalMessage").text('Are you sure you want to force this node to enroll again? It is removed until it enrolls.');
+  $('#confirm_action').click(function () {
+    $('#confirmModal').modal('hide');
+    resolveIdentity(_uuid, 'reenroll', _redirect);
+  });
+  $("#confirmModal").modal();
+}
+
+function resolveIdentity(_uuid, _action, _redirect) {
+  var _csrftoken = $("#csrftoken").val();
+  var _url = urlPrefix + '/node/identity';
+  var data = {
+    csrftoken: _csrftoken,
+    action: _action,
+    uuid: _uuid,
+  };
+  sendPostRequest(data, _url, _redirect, false);
+}
+
+function deleteScheduleOverride(_uuid, _id) {
+  var _csrftoken = $("#csrftoken").val();
+  var _url = urlPrefix + '/node/schedule-override';
+  var data = {
+    csrftoken: _csrftoken,
+    action: 'delete',
+    uuid: _uuid,
+    id: _id,
+  };
+  sendPostRequest(data, _url, window.location.pathname, false);
+}
+
+function compareNodes(_targets) {
+  if (_targets.length < 1 || _targets.length > 2) {
+    $("#warningModalMessage").text("Select one node to compare with the baseline of its environment, or two nodes to compare them");
+    $("#warningModal").modal();
+    return;
+  }
+  var _b = _targets[0].split('/')[0] + '/baseline';
+  if (_targets.length === 2) {
+    _b = _targets[1];
+  }
+  window.location.href = urlPrefix + '/nodes/compare?a=' + encodeURIComponent(_targets[0]) + '&b=' + encodeURIComponent(_b);
+}
+
+// Quick actions are previewed first, so users confirm exactly what runs on which nodes
+function quickActionSelected(_table, _env, _name) {
+  var uuids = [];
+  $.each($('#' + _table).DataTable().rows({search:'applied', selected: true}).data(), function() {
+    uuids.push(this.uuid);
+  });
+  if (uuids.length === 0) {
+    $("#warningModalMessage").text("You must select one or more nodes");
+    $("#warningModal").modal();
+    return;
+  }
+  previewQuickAction(_env, _name, uuids);
+}
+
+function previewQuickAction(_env, _name, _uuids) {
+  var _url = urlPrefix + '/quickactions/' + _env;
+  var data = {
+    csrftoken: $("#csrftoken").val(),
+    action: 'preview',
+    name: _name,
+    uuids: _uuids
+  };
+  sendPostRequest(data, _url, '', false, function (plan) {
+    var _message = plan.summary + '. Are you sure you want to continue?';
+    var _notices = $("#notices").data("confirm");
+    if (_notices && plan.type !== 'tag') {
+      _message = _notices + ' ' + _message;
+    }
+    $("#confirmModalMessage").text(_message);
+    $('#confirm_action').off('click').click(function () {
+      $('#confirmModal').modal('hide');
+      data.action = 'run';
+      data.version = plan.version;
+      data.uuids = plan.targets;
+      data.confirm_notices = !!_notices;
+      sendPostRequest(data, _url, '', true);
+    });
+    $("#confirmModal").modal();
+  });
+}
+
+function showLineageDiff() {
+  var _uuid = window.location.pathname.substring(urlPrefix.length).split('/')[2];
+  window.location.href = urlPrefix + '/node/' + _uuid + '/config-history?from=' + $("#lineage_from").val() + '&to=' + $("#lineage_to").val();
+}
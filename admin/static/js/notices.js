@@ -0,0 +1,114 @@
+// Notices are rendered with the header and shown at the top of the content of the page
+$(document).ready(function () {
+  var _notices = $("#notices");
+  if (_notices.children().length > 0) {
+    _notices.prependTo($("main.main .container-fluid").first()).removeClass("d-none");
+  }
+});
+
+function ackNotice(_id) {
+  var data = {
+    csrftoken: $("#csrftoken").val(),
+    action: 'ack',
+    id: _id
+  };
+  sendPostRequest(data, urlPrefix + '/notices', window.location.href, false);
+}
+
+// Critical notices of the environment need a confirmation to run queries and carves
+function confirmNotices(_data, _send) {
+  var _confirm = $("#notices").data("confirm");
+  if (!_confirm) {
+    _send(_data);
+    return;
+  }
+  $("#confirmModalMessage").text(_confirm + ' Are you sure you want to continue?');
+  $('#confirm_action').off('click').click(function () {
+    $('#confirmModal').modal('hide');
+    _data.confirm_notices = true;
+    _send(_data);
+  });
+  $("#confirmModal").modal();
+}
+
+// Launches over many targets in some categories of environments need the name of the environment typed
+function sendLaunch(_data, _url, _redir, _callback) {
+  sendPostRequest(_data, _url, _redir, false, _callback, function (jqXhr) {
+    if (jqXhr.status !== 428) {
+      return false;
+    }
+    var _name = $('<input class="form-control mt-2" type="text" placeholder="Environment name">');
+    $("#confirmModalMessage").empty()
+      .append($('<p>').text('This launch reaches many nodes, type the name of the environment to confirm it.'))
+      .append(_name);
+    $('#confirm_action').off('click').click(function () {
+      $('#confirmModal').modal('hide');
+      _data.confirm_name = _name.val();
+      sendPostRequest(_data, _url, _redir, false, _callback);
+    });
+    $("#confirmModal").modal();
+    return true;
+  });
+}
+
+function noticeData() {
+  return {
+    csrftoken: $("#csrftoken").val(),
+    id: parseInt($("#notice_id").val(), 10) || 0,
+    message: $("#notice_message").val(),
+    severity: $("#notice_severity").val(),
+    environments: $("#notice_environments").val(),
+    expires_at: $("#notice_expires").val(),
+    require_ack: $('#notice_ack').is(':checked'),
+    confirm_actions: $('#notice_confirm').is(':checked')
+  };
+}
+
+function saveNotice() {
+  var data = noticeData();
+  if (data.message === "") {
+    $("#warningModalMessage").text("Message can not be empty");
+    $("#warningModal").modal();
+    return;
+  }
+  data.action = data.id > 0 ? 'update' : 'create';
+  sendPostRequest(data, window.location.pathname, window.location.pathname, false);
+}
+
+function editNotice(_id, _message, _severity, _environments, _expires, _ack, _confirm) {
+  $("#notice_id").val(_id);
+  $("#notice_message").val(_message);
+  $("#notice_severity").val(_severity);
+  $("#notice_environments").val(_environments === "" ? [] : _environments.split(",")).trigger('change');
+  $("#notice_expires").val(_expires);
+  $('#notice_ack').prop('checked', _ack);
+  $('#notice_confirm').prop('checked', _confirm);
+  $("#notice_form_title").text("Edit notice " + _id);
+  window.scrollTo(0, 0);
+}
+
+function clearNotice() {
+  $("#notice_id").val("");
+  $("#notice_message").val("");
+  $("#notice_severity").val("info");
+  $("#notice_environments").val([]).trigger('change');
+  $("#notice_expires").val("");
+  $('#notice_ack').prop('checked', false);
+  $('#notice_confirm').prop('checked', false);
+  $("#notice_form_title").text("New notice");
+}
+
+function confirmDeleteNotice(_id) {
+  var modal_message = 'Are you sure you want to remove the notice ' + _id + '? It is kept in the history.';
+  $("#confirmModalMessage").text(modal_message);
+  $('#confirm_action').off('click').click(function () {
+    $('#confirmModal').modal('hide');
+    var data = {
+      csrftoken: $("#csrftoken").val(),
+      action: 'delete',
+      id: _id
+    };
+    sendPostRequest(data, window.location.pathname, window.location.pathname, false);
+  });
+  $("#confirmModal").modal();
+}
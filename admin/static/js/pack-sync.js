@@ -0,0 +1,28 @@
+function sendPackSync(data) {
+  data.csrftoken = $("#csrftoken").val();
+  sendPostRequest(data, window.location.pathname, window.location.href, false);
+}
+
+function savePackSource() {
+  var data = {
+    action: 'configure',
+    url: $("#source_url").val(),
+    branch: $("#source_branch").val(),
+    path_glob: $("#source_glob").val(),
+    auth_kind: $("#source_auth").val(),
+    credential: $("#source_credential").val(),
+    interval: parseInt($("#source_interval").val(), 10) || 0,
+    enabled: $("#source_enabled").is(':checked'),
+  };
+  sendPackSync(data);
+}
+
+function confirmDeletePackSource() {
+  var modal_message = 'Are you sure you want to delete the pack source? Packs synced from it are kept and managed manually.';
+  $("#confirmModalMessage").text(modal_message);
+  $('#confirm_action').click(function () {
+    $('#confirmModal').modal('hide');
+    sendPackSync({ action: 'delete' });
+  });
+  $("#confirmModal").modal();
+}
@@ -0,0 +1,40 @@
+function editQueryPolicy(_subject, _mode, _tables, _columns) {
+  $("#policy_subject").val(_subject);
+  $("#policy_mode").val(_mode);
+  $("#policy_tables").val(_tables);
+  $("#policy_columns").val(_columns);
+  $("#policy_tables").focus();
+}
+
+function setQueryPolicy(_url) {
+  var _subject = $("#policy_subject").val();
+  if (_subject === "") {
+    $("#warningModalMessage").text("Subject can not be empty");
+    $("#warningModal").modal();
+    return;
+  }
+  var data = {
+    csrftoken: $("#csrftoken").val(),
+    action: "set",
+    subject: _subject,
+    mode: $("#policy_mode").val(),
+    tables: $("#policy_tables").val(),
+    columns: $("#policy_columns").val(),
+  };
+  sendPostRequest(data, _url, window.location.pathname, false);
+}
+
+function deleteQueryPolicy(_url, _subject) {
+  var modal_message = 'Are you sure you want to delete the query policy for ' + _subject + '?';
+  $("#confirmModalMessage").text(modal_message);
+  $('#confirm_action').click(function () {
+    $('#confirmModal').modal('hide');
+    var data = {
+      csrftoken: $("#csrftoken").val(),
+      action: "delete",
+      subject: _subject,
+    };
+    sendPostRequest(data, _url, window.location.pathname, false);
+  });
+  $("#confirmModal").modal();
+}
@@ -0,0 +1,71 @@
+function showTemplateModal(_name) {
+  var _template = (queryTemplates || []).find(function (t) { return t.name === _name; });
+  if (_template) {
+    $("#template_modal_title").text('Update template ' + _name);
+    $("#template_action").val('update');
+    $("#template_name").val(_template.name).prop('readonly', true);
+    $("#template_description").val(_template.description);
+    $("#template_query").val(_template.query);
+    $("#template_variables").val((_template.variables || []).map(function (v) {
+      return v.name + ':' + v.type + (v.values ? ':' + v.values.join(',') : '');
+    }).join('\n'));
+    $("#template_platforms").val((_template.platforms || []).join(','));
+    $("#template_risk").val(_template.risk);
+    $("#template_team").val(_template.team);
+    $("#template_approved").prop('checked', _template.approved);
+  } else {
+    $("#template_modal_title").text('Add template');
+    $("#template_action").val('create');
+    $("#template_name").val('').prop('readonly', false);
+    $("#template_description").val('');
+    $("#template_query").val('');
+    $("#template_variables").val('');
+    $("#template_platforms").val('');
+    $("#template_team").val('');
+    $("#template_approved").prop('checked', false);
+  }
+  $("#template_changelog").val('');
+  $('#templateModal').modal();
+}
+
+function saveTemplate() {
+  var _csrftoken = $("#csrftoken").val();
+  var _url = window.location.pathname;
+  var data = {
+    csrftoken: _csrftoken,
+    action: $("#template_action").val(),
+    name: $("#template_name").val(),
+    description: $("#template_description").val(),
+    query: $("#template_query").val(),
+    variables: $("#template_variables").val(),
+    platforms: $("#template_platforms").val(),
+    risk: $("#template_risk").val(),
+    team: $("#template_team").val(),
+    approved: $('#template_approved').is(':checked'),
+    changelog: $("#template_changelog").val(),
+  };
+  sendPostRequest(data, _url, _url, false);
+}
+
+function confirmDeleteTemplate(_name) {
+  var modal_message = 'Are you sure you want to delete the template ' + _name + '? Its versions are kept.';
+  $("#confirmModalMessage").text(modal_message);
+  $('#confirm_action').click(function () {
+    $('#confirmModal').modal('hide');
+    deleteTemplate(_name);
+  });
+  $("#confirmModal").modal();
+}
+
+function deleteTemplate(_name) {
+  var _csrftoken = $("#csrftoken").val();
+  var _url = window.location.pathname;
+  var data = {
+    csrftoken: _csrftoken,
+    action: 'delete',
+    name: _name,
+  };
+  sendPostRequest(data, _url, '', false, function () {
+    undoDelete('templates', [_name], '', _url);
+  });
+}
@@ -1,4 +1,4 @@
-function sendQuery(_queryUrl, _redir) {
+function sendQuery(_queryUrl, _redir, _profileRedir) {
   var _csrftoken = $("#csrftoken").val();
   var _env_list = $("#target_env").val();
   var _platform_list = $("#target_platform").val();
@@ -6,11 +6,19 @@ function sendQuery(_queryUrl, _redir) {
   var _host_list = $("#target_hosts").val();
   var _query_name = $("#save_query_name").val();
   var _query_save = $('#save_query_check').is(':checked') ? true : false;
+  var _query_profile = $('#profile_query_check').is(':checked') ? true : false;
+  var _query_sample = parseInt($("#profile_sample").val(), 10) || 0;
+  var _query_context = $('#context_query_check').is(':checked') ? true : false;
+  var _query_group = $('#group_query_check').is(':checked') ? true : false;
+  var _query_override = $('#override_query_check').is(':checked') ? true : false;
   var editor = $('.CodeMirror')[0].CodeMirror;
   var _query = editor.getValue();
 
-  // Making sure targets are specified
-  if (_env_list.length === 0 && _platform_list.length === 0 && _uuid_list.length === 0 && _host_list.length === 0) {
+  var _window = targetWindow();
+  var _attributes = targetAttributes();
+
+  // Making sure targets are specified, a time window alone targets the whole environment
+  if (_env_list.length === 0 && _platform_list.length === 0 && _uuid_list.length === 0 && _host_list.length === 0 && !targetWindowSet(_window) && _attributes.length === 0) {
     $("#warningModalMessage").text("No targets have been specified");
     $("#warningModal").modal();
     return;
@@ -39,15 +47,31 @@ function sendQuery(_queryUrl, _redir) {
     $("#warningModal").modal();
     return;
   }
+  // Queries from templates send the values of the variables instead of the SQL
+  var _template = "";
+  var _variables = {};
+  if ($('#template_query_check').is(':checked')) {
+    _template = $("#template_name").val();
+    if (_template === "") {
+      $("#warningModalMessage").text("Template can not be empty");
+      $("#warningModal").modal();
+      return;
+    }
+    _variables = templateVariables();
+    if (_variables === null) {
+      return;
+    }
+    _query = "";
+  }
   // Making sure query isn't empty
   console.log(_query);
-  if (_query === "") {
+  if (_query === "" && _template === "") {
     $("#warningModalMessage").text("Query can not be empty");
     $("#warningModal").modal();
     return;
   }
   // Make sure semicolon always in the query
-  if (_query.slice(-1) !== ';') {
+  if (_query !== "" && _query.slice(-1) !== ';') {
     _query = _query + ';';
   }
   var data = {
@@ -58,9 +82,81 @@ function sendQuery(_queryUrl, _redir) {
     host_list: _host_list,
     save: _query_save,
     name: _query_name,
-    query: _query
+    query: _query,
+    profile: _query_profile,
+    sample: _query_sample,
+    template: _template,
+    variables: _variables,
+    enrolled_after: _window.enrolled_after,
+    enrolled_before: _window.enrolled_before,
+    seen_after: _window.seen_after,
+    seen_before: _window.seen_before,
+    attribute_list: _attributes,
+    expiration: $("#query_expiration").val(),
+    visibility: $("#query_visibility").val(),
+    visibility_team: $("#query_visibility").val() === "team" ? $("#query_visibility_team").val() : ""
+  };
+  // Investigation context is optional
+  if (_query_context) {
+    data.description = $("#context_description").val();
+    data.ticket_id = $("#context_ticket_id").val();
+    data.ticket_url = $("#context_ticket_url").val();
+    data.labels = $("#context_labels").val().split("\n");
+  }
+  // Concurrency group is optional
+  if (_query_group) {
+    data.group = $("#group_name").val();
+    data.priority = parseInt($("#group_priority").val(), 10) || 0;
+  }
+  // Only admins can override query policies, with a justification
+  if (_query_override) {
+    data.override = true;
+    data.justification = $("#override_justification").val();
+  }
+  confirmNotices(data, function (_data) {
+    if (_query_profile) {
+      // Profile runs go to the pre-flight report
+      sendLaunch(_data, _queryUrl, "", function (response) {
+        window.location.replace(_profileRedir + response.message);
+      });
+      return;
+    }
+    sendLaunch(_data, _queryUrl, _redir);
+  });
+}
+
+// Estimate how many of the targets check in before the query expires, from their check-in history
+function updateCoverage(_env) {
+  var _env_list = $("#target_env").val() || [];
+  var _platform_list = $("#target_platform").val() || [];
+  var _target_list = ($("#target_uuids").val() || []).concat($("#target_hosts").val() || []);
+  if (_env_list.length === 0 && _platform_list.length === 0 && _target_list.length === 0) {
+    $("#coverage_estimate").text("Select targets to estimate coverage");
+    return;
+  }
+  // Environments and all platforms target every node of the environment
+  if (_env_list.length > 0 || _platform_list.includes("all_platforms_99")) {
+    _platform_list = [];
+    _target_list = [];
+  }
+  var _url = urlPrefix + '/json/coverage/' + _env +
+    '?window=' + encodeURIComponent($("#query_expiration").val()) +
+    '&targets=' + encodeURIComponent(_target_list.join(',')) +
+    '&platforms=' + encodeURIComponent(_platform_list.join(','));
+  $.getJSON(_url, function (estimate) {
+    $("#coverage_estimate").text(estimate.summary);
+  }).fail(function () {
+    $("#coverage_estimate").text("Coverage can not be estimated");
+  });
+}
+
+function launchProfile(_url, _redir) {
+  var data = {
+    csrftoken: $("#csrftoken").val()
   };
-  sendPostRequest(data, _queryUrl, _redir, false);
+  sendPostRequest(data, _url, "", false, function (response) {
+    window.location.replace(_redir + response.message);
+  });
 }
 
 function clearQuery() {
@@ -80,18 +176,24 @@ $("#query").keyup(function (event) {
 });
 
 function deleteQueries(_names, _url) {
-  actionQueries('delete', _names, _url, window.location.pathname);
+  var _env = window.location.pathname.substring(urlPrefix.length).split('/')[2];
+  actionQueries('delete', _names, _url, '', function () {
+    undoDelete('queries', _names, _env, window.location.pathname);
+  });
 }
 
 function deleteSavedQueries(_names, _url) {
-  actionQueries('saved_delete', _names, _url, window.location.pathname);
+  var _env = window.location.pathname.substring(urlPrefix.length).split('/')[2];
+  actionQueries('saved_delete', _names, _url, '', function () {
+    undoDelete('saved', _names, _env, window.location.pathname);
+  });
 }
 
 function completeQueries(_names, _url, _redir) {
   actionQueries('complete', _names, _url, _redir);
 }
 
-function actionQueries(_action, _names, _url, _redir) {
+function actionQueries(_action, _names, _url, _redir, _callback) {
   var _csrftoken = $("#csrftoken").val();
 
   var data = {
@@ -99,15 +201,15 @@ function actionQueries(_action, _names, _url, _redir) {
     names: _names,
     action: _action
   };
-  sendPostRequest(data, _url, _redir, false);
+  sendPostRequest(data, _url, _redir, false, _callback);
 }
 
-function confirmDeleteQueries(_names) {
+function confirmDeleteQueries(_names, _url) {
   var modal_message = 'Are you sure you want to delete ' + _names.length + ' query(s)?';
   $("#confirmModalMessage").text(modal_message);
   $('#confirm_action').click(function () {
     $('#confirmModal').modal('hide');
-    deleteQueries(_names);
+    deleteQueries(_names, _url);
   });
   $("#confirmModal").modal();
 }
@@ -127,6 +229,15 @@ function queryResultLink(link, query, url) {
   return '<span class="query-link"><a href="' + url + '">' + query + '</a> - ' + external_link + '</span> ';
 }
 
+function toggleProfileQuery() {
+  if ($('#profile_query_check').is(':checked')) {
+    $('#collapseProfile').removeClass("collapse");
+    $('#profile_sample').focus();
+  } else {
+    $('#collapseProfile').addClass("collapse");
+  }
+}
+
 function toggleSaveQuery() {
   $('#save_query_name').val('');
   if ($('#save_query_check').is(':checked')) {
@@ -138,3 +249,160 @@ function toggleSaveQuery() {
     $('#collapseName').addClass("collapse");
   }
 }
+
+function toggleGroupQuery() {
+  if ($('#group_query_check').is(':checked')) {
+    $('#collapseGroup').removeClass("collapse");
+    $('#group_name').focus();
+  } else {
+    $('#collapseGroup').addClass("collapse");
+  }
+}
+
+function toggleOverrideQuery() {
+  if ($('#override_query_check').is(':checked')) {
+    $('#collapseOverride').removeClass("collapse");
+    $('#override_justification').focus();
+  } else {
+    $('#collapseOverride').addClass("collapse");
+  }
+}
+
+function toggleContextQuery() {
+  if ($('#context_query_check').is(':checked')) {
+    $('#collapseContext').removeClass("collapse");
+    $('#context_description').focus();
+  } else {
+    $('#collapseContext').addClass("collapse");
+  }
+}
+
+function saveQueryContext(_url, _name) {
+  var data = {
+    csrftoken: $("#csrftoken").val(),
+    name: _name,
+    description: $("#context_description").val(),
+    ticket_id: $("#context_ticket_id").val(),
+    ticket_url: $("#context_ticket_url").val(),
+    labels: $("#context_labels").val().split("\n")
+  };
+  sendPostRequest(data, _url, window.location.pathname, false);
+}
+
+function saveQueryVisibility(_url, _name) {
+  var data = {
+    csrftoken: $("#csrftoken").val(),
+    name: _name,
+    visibility: $("#visibility_value").val(),
+    team: $("#visibility_team").val()
+  };
+  sendPostRequest(data, _url, window.location.pathname, false);
+}
+
+function breakGlass(_url, _name) {
+  var data = {
+    csrftoken: $("#csrftoken").val(),
+    name: _name,
+    reason: $("#break_glass_reason").val()
+  };
+  sendPostRequest(data, _url, window.location.pathname, false);
+}
+
+function shareQueries(_url) {
+  var data = {
+    csrftoken: $("#csrftoken").val(),
+    queries: $("#share_queries").val().split("\n"),
+    format: $("#share_format").val(),
+    drop_ips: $("#share_drop_ips").is(':checked'),
+    deny_columns: $("#share_deny_columns").val()
+  };
+  sendPostRequest(data, _url, window.location.pathname, false);
+}
+
+function cancelExport(_url, _job) {
+  var data = {
+    csrftoken: $("#csrftoken").val(),
+    job: _job
+  };
+  sendPostRequest(data, _url, window.location.pathname, false);
+}
+
+function rehydrateQuery(_url, _name) {
+  var data = {
+    csrftoken: $("#csrftoken").val(),
+    name: _name
+  };
+  sendPostRequest(data, _url, window.location.pathname, false);
+}
+
+function toggleTemplateQuery() {
+  $('#template_name').val('');
+  $('#template_variables').empty();
+  $('#template_description').text('');
+  var editor = $('.CodeMirror')[0].CodeMirror;
+  editor.setValue("");
+  if ($('#template_query_check').is(':checked')) {
+    $('#collapseTemplate').removeClass("collapse");
+    editor.setOption("readOnly", true);
+  } else {
+    $('#collapseTemplate').addClass("collapse");
+    editor.setOption("readOnly", false);
+  }
+}
+
+function selectTemplate() {
+  var _name = $("#template_name").val();
+  var _container = $('#template_variables');
+  var editor = $('.CodeMirror')[0].CodeMirror;
+  _container.empty();
+  $('#template_description').text('');
+  editor.setValue("");
+  var _template = (queryTemplates || []).find(function (t) { return t.name === _name; });
+  if (!_template) {
+    return;
+  }
+  var _platforms = (_template.platforms && _template.platforms.length > 0) ? _template.platforms.join(', ') : 'all';
+  $('#template_description').text(_template.description + ' [team: ' + _template.team + ', platforms: ' + _platforms + ']');
+  editor.setValue(_template.query);
+  (_template.variables || []).forEach(function (v) {
+    var _group = $('<fieldset class="form-group"></fieldset>');
+    _group.append($('<label></label>').attr('for', 'template_var_' + v.name).text(v.name + ' (' + v.type + '):'));
+    var _input;
+    if (v.type === 'enum') {
+      _input = $('<select class="form-control template-variable"></select>');
+      _input.append($('<option value=""></option>'));
+      v.values.forEach(function (value) {
+        _input.append($('<option></option>').attr('value', value).text(value));
+      });
+    } else if (v.type === 'int') {
+      _input = $('<input class="form-control template-variable" type="number" step="1">');
+    } else {
+      _input = $('<input class="form-control template-variable" type="text" autocomplete="off">');
+    }
+    _input.attr('id', 'template_var_' + v.name).attr('data-name', v.name).attr('data-type', v.type);
+    _group.append(_input);
+    _container.append(_group);
+  });
+}
+
+function templateVariables() {
+  var _variables = {};
+  var _valid = true;
+  $('.template-variable').each(function () {
+    var _name = $(this).data('name');
+    var _value = $(this).val();
+    $(this).removeClass('is-invalid');
+    if (_value === "" || ($(this).data('type') === 'int' && !/^-?[0-9]+$/.test(_value))) {
+      $(this).addClass('is-invalid');
+      _valid = false;
+      return;
+    }
+    _variables[_name] = _value;
+  });
+  if (!_valid) {
+    $("#warningModalMessage").text("Template variables are missing or invalid");
+    $("#warningModal").modal();
+    return null;
+  }
+  return _variables;
+}
@@ -0,0 +1,15 @@
+function confirmApproveRejection(_id, _hostname) {
+  var modal_message = 'Are you sure you want to approve the node ' + _hostname + '? It will get the schedule and queries of the environment.';
+  $("#confirmModalMessage").text(modal_message);
+  $('#confirm_action').click(function () {
+    $('#confirmModal').modal('hide');
+    var _url = window.location.pathname;
+    var data = {
+      csrftoken: $("#csrftoken").val(),
+      action: 'approve',
+      id: _id,
+    };
+    sendPostRequest(data, _url, _url, false);
+  });
+  $("#confirmModal").modal();
+}
@@ -0,0 +1,59 @@
+function generateReport() {
+  var _csrftoken = $("#csrftoken").val();
+  var _url = window.location.pathname;
+  var data = {
+    csrftoken: _csrftoken,
+    action: 'generate',
+    environment: $("#report_env").val(),
+    category: $("#report_env option:selected").data("category") || "",
+    period: $("#report_period").val(),
+    format: $("#report_format").val(),
+  };
+  $("#report_generate").prop('disabled', true);
+  sendPostRequest(data, _url, _url, false);
+}
+
+function scheduleReport() {
+  var _csrftoken = $("#csrftoken").val();
+  var _url = window.location.pathname;
+  var data = {
+    csrftoken: _csrftoken,
+    action: 'schedule',
+    environment: $("#schedule_env").val(),
+    frequency: $("#schedule_frequency").val(),
+    format: $("#schedule_format").val(),
+    recipients: $("#schedule_recipients").val(),
+  };
+  sendPostRequest(data, _url, _url, false);
+}
+
+function confirmDeleteReport(_id, _name) {
+  var modal_message = 'Are you sure you want to delete the report ' + _name + '?';
+  $("#confirmModalMessage").text(modal_message);
+  $('#confirm_action').click(function () {
+    $('#confirmModal').modal('hide');
+    deleteReport(_id, 'delete');
+  });
+  $("#confirmModal").modal();
+}
+
+function confirmDeleteSchedule(_id, _env) {
+  var modal_message = 'Are you sure you want to delete the report schedule for ' + _env + '?';
+  $("#confirmModalMessage").text(modal_message);
+  $('#confirm_action').click(function () {
+    $('#confirmModal').modal('hide');
+    deleteReport(_id, 'unschedule');
+  });
+  $("#confirmModal").modal();
+}
+
+function deleteReport(_id, _action) {
+  var _csrftoken = $("#csrftoken").val();
+  var _url = window.location.pathname;
+  var data = {
+    csrftoken: _csrftoken,
+    action: _action,
+    id: parseInt(_id),
+  };
+  sendPostRequest(data, _url, _url, false);
+}
@@ -0,0 +1,147 @@
+function sendResponses(data) {
+  var _url = window.location.pathname;
+  data.csrftoken = $("#csrftoken").val();
+  sendPostRequest(data, _url, _url, false);
+}
+
+function saveResponsesConfig() {
+  var _allowed = [];
+  $(".responses-allowed:checked").each(function () {
+    _allowed.push($(this).val());
+  });
+  sendResponses({
+    action: 'config',
+    enabled: $("#responses_enabled").is(':checked'),
+    allowed: _allowed.join(','),
+    cooldown: parseInt($("#responses_cooldown").val()),
+    max_per_hour: parseInt($("#responses_max").val()),
+  });
+}
+
+function confirmResetCircuit() {
+  var modal_message = 'Are you sure you want to reset the circuit breaker? Response actions will be launched again.';
+  $("#confirmModalMessage").text(modal_message);
+  $('#confirm_action').click(function () {
+    $('#confirmModal').modal('hide');
+    sendResponses({ action: 'reset' });
+  });
+  $("#confirmModal").modal();
+}
+
+function changeTriggerKind() {
+  if ($("#trigger_kind").val() === 'carve') {
+    $(".trigger-query").hide();
+    $(".trigger-carve").show();
+  } else {
+    $(".trigger-carve").hide();
+    $(".trigger-query").show();
+  }
+}
+
+function showTriggerModal(_name, _query, _column, _value, _kind, _template, _variables, _path, _enabled) {
+  $("#trigger_name").val(_name || '');
+  $("#trigger_name").prop('readonly', !!_name);
+  $("#trigger_query").val(_query || '');
+  $("#trigger_column").val(_column || '');
+  $("#trigger_value").val(_value || '');
+  $("#trigger_kind").val(_kind || 'query');
+  $("#trigger_template").val(_template || $("#trigger_template option:first").val());
+  $("#trigger_variables").val(_variables || '');
+  $("#trigger_path").val(_path || '');
+  $("#trigger_enabled").prop('checked', _enabled === undefined ? true : _enabled);
+  changeTriggerKind();
+  $("#triggerModal").modal();
+}
+
+function saveTrigger() {
+  var _kind = $("#trigger_kind").val();
+  var data = {
+    action: 'trigger',
+    name: $("#trigger_name").val(),
+    query: $("#trigger_query").val(),
+    column: $("#trigger_column").val(),
+    value: $("#trigger_value").val(),
+    kind: _kind,
+    enabled: $("#trigger_enabled").is(':checked'),
+  };
+  if (_kind === 'carve') {
+    data.path = $("#trigger_path").val();
+  } else {
+    data.template = $("#trigger_template").val();
+    data.variables = $("#trigger_variables").val();
+  }
+  $("#triggerModal").modal('hide');
+  sendResponses(data);
+}
+
+function confirmDeleteTrigger(_name) {
+  var modal_message = 'Are you sure you want to delete the trigger ' + _name + '? Recorded actions are kept.';
+  $("#confirmModalMessage").text(modal_message);
+  $('#confirm_action').click(function () {
+    $('#confirmModal').modal('hide');
+    sendResponses({ action: 'delete', name: _name });
+  });
+  $("#confirmModal").modal();
+}
+
+function changeMuteKind() {
+  var _kind = $("#mute_kind").val();
+  if (_kind === 'responses') {
+    $(".mute-rule").show();
+  } else {
+    $(".mute-rule").hide();
+  }
+  if (_kind === 'storage_quota') {
+    $(".mute-node").hide();
+  } else {
+    $(".mute-node").show();
+  }
+}
+
+function changeMutePermanent() {
+  var _permanent = $("#mute_permanent").is(':checked');
+  $("#mute_hours").prop('disabled', _permanent);
+  $("#mute_notify").prop('disabled', _permanent);
+}
+
+function showMuteModal(_uuid, _kind, _rule) {
+  if (!$("#mute_uuid").prop('readonly')) {
+    $("#mute_uuid").val(_uuid || '');
+  }
+  $("#mute_kind").val(_kind || 'responses');
+  $("#mute_rule").val(_rule || '');
+  $("#mute_reason").val('');
+  $("#mute_hours").val(24);
+  $("#mute_permanent").prop('checked', false);
+  $("#mute_notify").prop('checked', false);
+  changeMuteKind();
+  changeMutePermanent();
+  $("#muteModal").modal();
+}
+
+function saveMute(_url) {
+  var _kind = $("#mute_kind").val();
+  var data = {
+    csrftoken: $("#csrftoken").val(),
+    action: 'mute',
+    mute_kind: _kind,
+    rule: _kind === 'responses' ? $("#mute_rule").val() : '',
+    uuid: _kind === 'storage_quota' ? '' : $("#mute_uuid").val(),
+    reason: $("#mute_reason").val(),
+    hours: parseInt($("#mute_hours").val()),
+    permanent: $("#mute_permanent").is(':checked'),
+    notify: $("#mute_notify").is(':checked'),
+  };
+  $("#muteModal").modal('hide');
+  sendPostRequest(data, _url, window.location.pathname, false);
+}
+
+function confirmUnmute(_url, _id) {
+  var modal_message = 'Are you sure you want to lift this mute? Notifications are delivered again.';
+  $("#confirmModalMessage").text(modal_message);
+  $('#confirm_action').click(function () {
+    $('#confirmModal').modal('hide');
+    sendPostRequest({ csrftoken: $("#csrftoken").val(), action: 'unmute', mute_id: _id }, _url, window.location.pathname, false);
+  });
+  $("#confirmModal").modal();
+}
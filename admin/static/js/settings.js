@@ -67,7 +67,7 @@ function changeDebug(_name, service) {
   var _csrftoken = $("#csrftoken").val();
   var _value = $("#" + _name + '_' + service).is(':checked');
 
-  var _url = '/settings/' + service;
+  var _url = urlPrefix + '/settings/' + service;
 
   var data = {
     csrftoken: _csrftoken,
@@ -78,3 +78,23 @@ function changeDebug(_name, service) {
   };
   sendPostRequest(data, _url, '', false);
 }
+
+function alwaysFiltersData(_action) {
+  return {
+    csrftoken: $("#csrftoken").val(),
+    action: _action,
+    types: $("#always_types").val(),
+    names: $("#always_names").val(),
+    disabled_envs: $("#always_disabled").val(),
+  };
+}
+
+function saveAlwaysFilters() {
+  var _url = window.location.pathname;
+  sendPostRequest(alwaysFiltersData('always'), _url, _url, false);
+}
+
+function estimateAlwaysFilters() {
+  var _url = window.location.pathname;
+  sendPostRequest(alwaysFiltersData('always-estimate'), _url, '', true);
+}
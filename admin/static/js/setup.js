@@ -0,0 +1,35 @@
+function setupStep(_step) {
+  $('#setup_tab_' + _step).tab('show');
+}
+
+function setupError(_msg) {
+  $("#errorModalMessageClient").text('Client: ' + _msg);
+  $("#errorModalMessageServer").text('');
+  $("#errorModal").modal();
+}
+
+function sendSetup() {
+  var _password = $("#setup_password").val();
+  if (_password !== $("#setup_password_confirm").val()) {
+    setupStep('user');
+    setupError('passwords do not match');
+    return;
+  }
+  var _hours = parseInt($("#setup_inactive").val(), 10);
+
+  var _url = urlPrefix + '/setup';
+  var data = {
+    token: $("#setup_token").val(),
+    username: $("#setup_username").val(),
+    password: _password,
+    email: $("#setup_email").val(),
+    fullname: $("#setup_fullname").val(),
+    environment: $("#setup_environment").val(),
+    hostname: $("#setup_hostname").val(),
+    template: $("#setup_template").val(),
+    inactive_hours: isNaN(_hours) ? 0 : _hours
+  };
+  sendPostRequest(data, _url, '', false, function(_data){
+    window.location.replace(_data.message);
+  });
+}
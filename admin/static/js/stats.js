@@ -1,6 +1,6 @@
 function statsRefresh(_target, _identifier) {
   $.ajax({
-    url: '/json/stats/' + _target + '/' + _identifier,
+    url: urlPrefix + '/json/stats/' + _target + '/' + _identifier,
     dataType: 'json',
     type: 'GET',
     contentType: 'application/json',
@@ -77,7 +77,9 @@ function deleteTag(_tag) {
     action: 'remove',
     name: _tag,
   };
-  sendPostRequest(data, _url, _url, false);
+  sendPostRequest(data, _url, '', false, function () {
+    undoDelete('tags', [_tag], '', _url);
+  });
 }
 
 function generateColor() {
@@ -43,6 +43,43 @@ function confirmDeleteUser(_user) {
   $("#confirmModal").modal();
 }
 
+function unlockLogin(_kind, _subject) {
+  var _csrftoken = $("#csrftoken").val();
+
+  var _url = window.location.pathname;
+
+  var data = {
+    csrftoken: _csrftoken,
+    action: 'unlock',
+  };
+  if (_kind === 'ip') {
+    data.ip_address = _subject;
+  } else {
+    data.username = _subject;
+  }
+  sendPostRequest(data, _url, _url, false);
+}
+
+function confirmUnlockLogin(_kind, _subject) {
+  var modal_message = 'Are you sure you want to unlock ' + _subject + '?';
+  $("#confirmModalMessage").text(modal_message);
+  $('#confirm_action').click(function () {
+    $('#confirmModal').modal('hide');
+    unlockLogin(_kind, _subject);
+  });
+  $("#confirmModal").modal();
+}
+
+function changeTeam(_action, _team, _username) {
+  var data = {
+    csrftoken: $("#csrftoken").val(),
+    action: _action,
+    team: _team,
+    username: _username
+  };
+  sendPostRequest(data, window.location.pathname + '/teams', window.location.pathname, false);
+}
+
 function changeAdminUser(_user) {
   var _csrftoken = $("#csrftoken").val();
   var _value = $("#" + _user).is(':checked');
@@ -94,7 +131,7 @@ function refreshUserToken() {
     csrftoken: _csrftoken,
     username: _username,
   };
-  sendPostRequest(data, '/tokens/' + _username + '/refresh', '', false, function (data) {
+  sendPostRequest(data, urlPrefix + '/tokens/' + _username + '/refresh', '', false, function (data) {
     console.log(data);
     $("#user_api_token").val(data.token);
     $("#user_token_expiration").val(data.expiration);
@@ -105,21 +142,22 @@ function refreshUserToken() {
 
 function showPermissions(_username) {
   $("#username_permissions").val(_username);
-  sendGetRequest('/users/permissions/' + _username, false, function (data) {
+  sendGetRequest(urlPrefix + '/users/permissions/' + _username, false, function (data) {
+    $('#permissionsModal .switch-input').prop('checked', false);
     for (var key in data) {
       $('.' + key + '-env').each(function() {
         var element_id = $(this).attr('id');
         if (element_id.search('permission-read') > 0) {
-          $(this).attr('checked', data[key].user);
+          $(this).prop('checked', data[key].user);
         }
         if (element_id.search('permission-query') > 0) {
-          $(this).attr('checked', data[key].query);
+          $(this).prop('checked', data[key].query);
         }
         if (element_id.search('permission-carve') > 0) {
-          $(this).attr('checked', data[key].carve);
+          $(this).prop('checked', data[key].carve);
         }
         if (element_id.search('permission-admin') > 0) {
-          $(this).attr('checked', data[key].admin);
+          $(this).prop('checked', data[key].admin);
         }
       });
     }
@@ -145,8 +183,25 @@ function savePermissions(_env_perm) {
     carve: _carve,
     admin: _admin,
   };
-  sendPostRequest(data, '/users/permissions/' + _username, '', false, function (data) {
+  sendPostRequest(data, urlPrefix + '/users/permissions/' + _username, '', false, function (data) {
+    console.log(data);
+  });
+}
+
+function bulkPermissions(_action) {
+  var _csrftoken = $("#csrftoken").val();
+  var _username = $("#username_permissions").val();
+
+  var data = {
+    csrftoken: _csrftoken,
+    action: _action,
+    environments: $("#bulk_envs").val() || [],
+    level: $("#bulk_level").val(),
+    from_user: _action === 'remove' ? '' : $("#bulk_from_user").val(),
+  };
+  sendPostRequest(data, urlPrefix + '/users/permissions/' + _username + '/bulk', '', false, function (data) {
     console.log(data);
+    showPermissions(_username);
   });
 }
 
@@ -0,0 +1,56 @@
+function sendWebhooks(data, _modal) {
+  var _url = window.location.pathname;
+  data.csrftoken = $("#csrftoken").val();
+  // Results of test events are shown without leaving the page
+  sendPostRequest(data, _url, _modal ? '' : window.location.href, _modal);
+}
+
+function showSecret(data) {
+  $("#endpoint_secret").val(data.secret);
+  $("#secretModal").modal();
+}
+
+function showEndpointModal() {
+  $("#endpoint_name").val('');
+  $("#endpoint_url").val('');
+  $(".endpoint-events").prop('checked', true);
+  $("#endpointModal").modal();
+}
+
+function createEndpoint() {
+  var _events = [];
+  $(".endpoint-events:checked").each(function () {
+    _events.push($(this).val());
+  });
+  var data = {
+    csrftoken: $("#csrftoken").val(),
+    action: 'create',
+    name: $("#endpoint_name").val(),
+    url: $("#endpoint_url").val(),
+    events: _events,
+  };
+  $("#endpointModal").modal('hide');
+  sendPostRequest(data, window.location.pathname, '', false, showSecret);
+}
+
+function confirmRotateSecret(_name) {
+  var modal_message = 'Are you sure you want to rotate the secret of ' + _name + '? Deliveries are signed with the new secret right away.';
+  $("#confirmModalMessage").text(modal_message);
+  $('#confirm_action').click(function () {
+    $('#confirmModal').modal('hide');
+    var data = { csrftoken: $("#csrftoken").val(), action: 'rotate', name: _name };
+    sendPostRequest(data, window.location.pathname, '', false, showSecret);
+  });
+  $("#confirmModal").modal();
+}
+
+function confirmDeleteEndpoint(_name) {
+  var modal_message = 'Are you sure you want to delete the endpoint ' + _name + '? Pending deliveries are dropped.';
+  $("#confirmModalMessage").text(modal_message);
+  $('#confirm_action').click(function () {
+    $('#confirmModal').modal('hide');
+    var data = { csrftoken: $("#csrftoken").val(), action: 'delete', name: _name };
+    sendPostRequest(data, window.location.pathname, window.location.pathname, false);
+  });
+  $("#confirmModal").modal();
+}
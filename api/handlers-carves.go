@@ -1,197 +0,0 @@
-package main
-
-import (
-	"encoding/json"
-	"fmt"
-	"log"
-	"net/http"
-
-	"github.com/gorilla/mux"
-	"github.com/jmpsec/osctrl/admin/sessions"
-	"github.com/jmpsec/osctrl/carves"
-	"github.com/jmpsec/osctrl/queries"
-	"github.com/jmpsec/osctrl/settings"
-	"github.com/jmpsec/osctrl/types"
-	"github.com/jmpsec/osctrl/users"
-	"github.com/jmpsec/osctrl/utils"
-)
-
-const (
-	metricAPICarvesReq = "carves-req"
-	metricAPICarvesErr = "carves-err"
-	metricAPICarvesOK  = "carves-ok"
-)
-
-// GET Handler to return a single carve in JSON
-func apiCarveShowHandler(w http.ResponseWriter, r *http.Request) {
-	incMetric(metricAPICarvesReq)
-	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
-	vars := mux.Vars(r)
-	// Extract name
-	name, ok := vars["name"]
-	if !ok {
-		apiErrorResponse(w, "error getting name", http.StatusInternalServerError, nil)
-		incMetric(metricAPICarvesErr)
-		return
-	}
-	// Extract environment
-	envVar, ok := vars["env"]
-	if !ok {
-		apiErrorResponse(w, "error with environment", http.StatusInternalServerError, nil)
-		incMetric(metricAPICarvesErr)
-		return
-	}
-	// Get environment
-	env, err := envs.Get(envVar)
-	if err != nil {
-		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
-		incMetric(metricAPICarvesErr)
-		return
-	}
-	// Get context data and check access
-	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
-	if !apiUsers.CheckPermissions(ctx[ctxUser], users.CarveLevel, env.UUID) {
-		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
-		incMetric(metricAPICarvesErr)
-		return
-	}
-	// Get carve by name
-	carve, err := filecarves.GetByQuery(name, env.ID)
-	if err != nil {
-		if err.Error() == "record not found" {
-			apiErrorResponse(w, "carve not found", http.StatusNotFound, err)
-		} else {
-			apiErrorResponse(w, "error getting carve", http.StatusInternalServerError, err)
-		}
-		incMetric(metricAPICarvesErr)
-		return
-	}
-	// Serialize and serve JSON
-	if settingsmgr.DebugService(settings.ServiceAPI) {
-		log.Printf("DebugService: Returned carve %s", name)
-	}
-	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, carve)
-	incMetric(metricAPICarvesOK)
-}
-
-// POST Handler to run a carve
-func apiCarvesRunHandler(w http.ResponseWriter, r *http.Request) {
-	incMetric(metricAPICarvesReq)
-	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
-	vars := mux.Vars(r)
-	// Extract environment
-	envVar, ok := vars["env"]
-	if !ok {
-		apiErrorResponse(w, "error with environment", http.StatusInternalServerError, nil)
-		incMetric(metricAPICarvesErr)
-		return
-	}
-	// Get environment
-	env, err := envs.Get(envVar)
-	if err != nil {
-		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
-		incMetric(metricAPICarvesErr)
-		return
-	}
-	// Get context data and check access
-	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
-	if !apiUsers.CheckPermissions(ctx[ctxUser], users.CarveLevel, env.UUID) {
-		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
-		incMetric(metricAPICarvesErr)
-		return
-	}
-	var c types.ApiDistributedCarveRequest
-	// Parse request JSON body
-	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
-		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
-		incMetric(metricAPICarvesErr)
-		return
-	}
-	// Path can not be empty
-	if c.Path == "" {
-		apiErrorResponse(w, "path can not be empty", http.StatusInternalServerError, nil)
-		incMetric(metricAPICarvesErr)
-		return
-	}
-	query := carves.GenCarveQuery(c.Path, false)
-	// Prepare and create new carve
-	carveName := carves.GenCarveName()
-	newQuery := queries.DistributedQuery{
-		Query:         query,
-		Name:          carveName,
-		Creator:       ctx[sessions.CtxUser],
-		Expected:      0,
-		Executions:    0,
-		Active:        true,
-		Completed:     false,
-		Deleted:       false,
-		Type:          queries.CarveQueryType,
-		Path:          c.Path,
-		EnvironmentID: env.ID,
-	}
-	if err := queriesmgr.Create(newQuery); err != nil {
-		apiErrorResponse(w, "error creating query", http.StatusInternalServerError, err)
-		incMetric(metricAPICarvesErr)
-		return
-	}
-	// Create UUID target
-	if (c.UUID != "") && nodesmgr.CheckByUUID(c.UUID) {
-		if err := queriesmgr.CreateTarget(carveName, queries.QueryTargetUUID, c.UUID); err != nil {
-			apiErrorResponse(w, "error creating carve UUID target", http.StatusInternalServerError, err)
-			incMetric(metricAPICarvesErr)
-			return
-		}
-	}
-	// Update value for expected
-	if err := queriesmgr.SetExpected(carveName, 1, env.ID); err != nil {
-		apiErrorResponse(w, "error setting expected", http.StatusInternalServerError, err)
-		incMetric(metricAPICarvesErr)
-		return
-	}
-	// Return query name as serialized response
-	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiQueriesResponse{Name: newQuery.Name})
-	incMetric(metricAPICarvesOK)
-}
-
-// GET Handler to return carves in JSON
-func apiCarvesShowHandler(w http.ResponseWriter, r *http.Request) {
-	incMetric(metricAPICarvesReq)
-	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
-	vars := mux.Vars(r)
-	// Extract environment
-	envVar, ok := vars["env"]
-	if !ok {
-		apiErrorResponse(w, "error with environment", http.StatusInternalServerError, nil)
-		incMetric(metricAPICarvesErr)
-		return
-	}
-	// Get environment
-	env, err := envs.Get(envVar)
-	if err != nil {
-		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
-		incMetric(metricAPICarvesErr)
-		return
-	}
-	// Get context data and check access
-	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
-	if !apiUsers.CheckPermissions(ctx[ctxUser], users.CarveLevel, env.UUID) {
-		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
-		incMetric(metricAPICarvesErr)
-		return
-	}
-	// Get carves
-	carves, err := filecarves.GetByEnv(env.ID)
-	if err != nil {
-		apiErrorResponse(w, "error getting carves", http.StatusInternalServerError, err)
-		incMetric(metricAPICarvesErr)
-		return
-	}
-	if len(carves) == 0 {
-		apiErrorResponse(w, "no carves", http.StatusNotFound, nil)
-		incMetric(metricAPICarvesErr)
-		return
-	}
-	// Serialize and serve JSON
-	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, carves)
-	incMetric(metricAPICarvesOK)
-}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+// contextValue to hold session data in the context
+type contextValue map[string]string
+
+// contextKey to help with the context key, to pass session data
+type contextKey string
+
+const (
+	// Username to use when there is no authentication
+	usernameAPI string = "osctrl-api-user"
+	// Key to identify request context
+	contextAPI string = "osctrl-api-context"
+)
+
+const (
+	ctxUser      = "user"
+	ctxKeyID     = "apikey"
+	ctxKeyEnv    = "apikey-env"
+	ctxKeyScopes = "apikey-scopes"
+)
+
+const (
+	adminLevel string = "admin"
+	userLevel  string = "user"
+)
+
+// Helper to verify if user is an admin
+func checkAdminLevel(level string) bool {
+	return (level == adminLevel)
+}
+
+// Helper to extract token from header
+func extractHeaderToken(r *http.Request) string {
+	reqToken := r.Header.Get("Authorization")
+	splitToken := strings.Split(reqToken, "Bearer")
+	if len(splitToken) != 2 {
+		return ""
+	}
+	return strings.TrimSpace(splitToken[1])
+}
+
+// Helper to check access for the user or the service key of a request. Service keys are checked by scope and
+// environment before the handler, so they have access to their environment and never to the whole service.
+// The admin level of the environment is only for keys with the explicit admin scope.
+func checkAccess(ctx contextValue, level users.AccessLevel, environment string) bool {
+	if ctx[ctxKeyID] != "" {
+		if environment == users.NoEnvironment || environment != ctx[ctxKeyEnv] {
+			return false
+		}
+		return level != users.AdminLevel || users.APIKey{Scopes: ctx[ctxKeyScopes]}.HasScope(users.ScopeAdmin)
+	}
+	return apiUsers.CheckPermissions(ctx[ctxUser], level, environment)
+}
+
+// Helper to check if a service key can use a route, it needs the scope of the route and the environment of the key
+func apiKeyAccess(r *http.Request, key users.APIKey, scope string) error {
+	if scope == "" || !key.HasScope(scope) {
+		return fmt.Errorf("key %s does not have scope %s", key.Name, scope)
+	}
+	envVar, ok := mux.Vars(r)["env"]
+	if !ok {
+		return fmt.Errorf("route without environment for key %s", key.Name)
+	}
+	env, err := envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		return fmt.Errorf("error getting environment %s for key %s: %v", envVar, key.Name, err)
+	}
+	if env.UUID != key.Environment {
+		return fmt.Errorf("key %s does not belong to environment %s", key.Name, env.Name)
+	}
+	return nil
+}
+
+// Helper to reject a token before verifying it, if the token or the source IP are delayed or locked out
+func throttledToken(w http.ResponseWriter, r *http.Request, token string) bool {
+	if throttle == nil {
+		return false
+	}
+	wait, err := throttle.Check(users.TokenSubjects(token, utils.GetIP(r)))
+	if err != nil {
+		log.Printf("error checking token attempts %v", err)
+	}
+	if wait > 0 {
+		w.Header().Set("Retry-After", users.RetryAfter(wait))
+		apiErrorResponse(w, users.ErrLoginThrottled.Error(), http.StatusTooManyRequests, nil)
+		return true
+	}
+	return false
+}
+
+// Helper to reject an invalid token, counting the failure for the token and the source IP so guessing is throttled.
+// Valid tokens are not counted, so concurrent requests of API clients are not delayed.
+func rejectToken(w http.ResponseWriter, r *http.Request, token string) {
+	if throttle != nil {
+		wait, err := throttle.Reserve(users.TokenSubjects(token, utils.GetIP(r)))
+		if err != nil {
+			log.Printf("error counting invalid token %v", err)
+		}
+		if wait > 0 {
+			w.Header().Set("Retry-After", users.RetryAfter(wait))
+			apiErrorResponse(w, users.ErrLoginThrottled.Error(), http.StatusTooManyRequests, nil)
+			return
+		}
+	}
+	http.Redirect(w, r, forbiddenPath, http.StatusForbidden)
+}
+
+// Handler to check access to a resource based on the authentication enabled, service keys need the scope of the route
+func handlerAuthCheck(h http.Handler, scope string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch apiConfig.Auth {
+		case settings.AuthNone:
+			// Set middleware values
+			s := make(contextValue)
+			s["user"] = "admin"
+			ctx := context.WithValue(r.Context(), contextKey(contextAPI), s)
+			// Access granted
+			h.ServeHTTP(w, r.WithContext(ctx))
+		case settings.AuthJWT:
+			// Set middleware values
+			token := extractHeaderToken(r)
+			if token == "" {
+				http.Redirect(w, r, forbiddenPath, http.StatusForbidden)
+				return
+			}
+			// Delayed and locked out tokens and addresses are rejected before verifying the token
+			if throttledToken(w, r, token) {
+				return
+			}
+			// Service keys are attributed to the key and not to a user
+			if users.IsAPIKey(token) {
+				key, valid := apiUsers.CheckAPIKey(token)
+				if !valid {
+					rejectToken(w, r, token)
+					return
+				}
+				if err := apiKeyAccess(r, key, scope); err != nil {
+					apiErrorResponse(w, "no access", http.StatusForbidden, err)
+					return
+				}
+				if err := apiUsers.UpdateAPIKeyUsage(key, utils.GetIP(r)); err != nil {
+					log.Printf("error updating usage for key %s: %v", key.Name, err)
+				}
+				log.Printf("%s %s by %s", r.Method, r.URL.Path, key.Actor())
+				// Set middleware values
+				s := make(contextValue)
+				s[ctxUser] = key.Actor()
+				s[ctxKeyID] = key.KeyID
+				s[ctxKeyEnv] = key.Environment
+				s[ctxKeyScopes] = key.Scopes
+				ctx := context.WithValue(r.Context(), contextKey(contextAPI), s)
+				// Access granted
+				h.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+			claims, valid := apiUsers.CheckToken(jwtConfig.JWTSecret, token)
+			if !valid {
+				rejectToken(w, r, token)
+				return
+			}
+			// Update metadata for the user
+			if err := apiUsers.UpdateTokenIPAddress(utils.GetIP(r), claims.Username); err != nil {
+				log.Printf("error updating token for user %s: %v", claims.Username, err)
+			}
+			// Set middleware values
+			s := make(contextValue)
+			s["user"] = claims.Username
+			ctx := context.WithValue(r.Context(), contextKey(contextAPI), s)
+			// Access granted
+			h.ServeHTTP(w, r.WithContext(ctx))
+		}
+	})
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestCheckAccessKey(t *testing.T) {
+	ctx := contextValue{ctxUser: "apikey:provisioner", ctxKeyID: "0123456789abcdef", ctxKeyEnv: "env-uuid", ctxKeyScopes: "queries:read,queries:write"}
+	assert.True(t, checkAccess(ctx, users.QueryLevel, "env-uuid"))
+	assert.False(t, checkAccess(ctx, users.AdminLevel, "env-uuid"))
+	assert.False(t, checkAccess(ctx, users.UserLevel, "other-uuid"))
+	// Only the explicit admin scope grants the admin level, and only in the environment of the key
+	ctx[ctxKeyScopes] = "queries:read," + users.ScopeAdmin
+	assert.True(t, checkAccess(ctx, users.AdminLevel, "env-uuid"))
+	assert.False(t, checkAccess(ctx, users.AdminLevel, "other-uuid"))
+	assert.False(t, checkAccess(ctx, users.AdminLevel, users.NoEnvironment))
+}
+
+func TestCheckAccessUser(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db, got error: %v", err)
+	}
+	defer mockDB.Close()
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: mockDB}), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("unable to create new postgres database: %v", err)
+	}
+	previous := apiUsers
+	apiUsers = &users.UserManager{DB: db}
+	defer func() { apiUsers = previous }()
+	// Users of JWT tokens are checked with their permissions
+	ctx := contextValue{ctxUser: "alice"}
+	access := func(level users.AccessLevel) bool {
+		mock.ExpectQuery(`SELECT count\(\*\) FROM "admin_users"`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectQuery(`SELECT \* FROM "user_permissions"`).WillReturnRows(
+			sqlmock.NewRows([]string{"username", "access_type", "access_value", "environment"}).
+				AddRow("alice", users.UserLevel, true, "env-uuid").
+				AddRow("alice", users.QueryLevel, false, "env-uuid"))
+		return checkAccess(ctx, level, "env-uuid")
+	}
+	assert.True(t, access(users.UserLevel))
+	assert.False(t, access(users.QueryLevel))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRouteScopes(t *testing.T) {
+	for _, route := range apiRoutes() {
+		if route.Scope == "" {
+			continue
+		}
+		assert.Contains(t, users.APIKeyScopes, route.Scope, "route %s with invalid scope", route.Path)
+		assert.True(t, strings.Contains(route.Path, "{env}"), "route %s with scope needs an environment", route.Path)
+		assert.False(t, strings.Contains(route.Path, "/apikeys"), "route %s can not be used by service keys", route.Path)
+	}
+}
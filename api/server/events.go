@@ -0,0 +1,66 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/cache"
+)
+
+const (
+	metricAPIEventPub = "api-event-published"
+	metricAPIEventErr = "api-event-err"
+)
+
+// Kinds of events published by changes to routes of each tag, so osctrl-tls reloads them
+var eventKinds = map[string]string{
+	tagEnvironments: cache.EventEnvironment,
+	tagQueries:      cache.EventQuery,
+	tagCarves:       cache.EventQuery,
+}
+
+// Helper to publish an event, errors are logged because osctrl-tls catches up with its periodic refresh.
+// Changes to environments also discard the environments map shared by osctrl-tls instances.
+func publishEvent(ctx context.Context, kind, environment, entity string) {
+	if kind == cache.EventEnvironment && redis != nil {
+		if err := redis.InvalidateEnvsCached(ctx); err != nil {
+			log.Printf("error invalidating cached environments %v", err)
+		}
+	}
+	if eventBus == nil {
+		return
+	}
+	if _, err := eventBus.Publish(ctx, kind, environment, entity); err != nil {
+		log.Printf("error publishing %s event %v", kind, err)
+		incMetric(metricAPIEventErr)
+		return
+	}
+	incMetric(metricAPIEventPub)
+}
+
+// Handler to publish an event of the kind after a successful change, for the environment of the route
+func handlerPublishEvent(h http.Handler, kind string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &idempotencyRecorder{header: w.Header()}
+		h.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		if rec.status < http.StatusBadRequest {
+			// Environments are identified by UUID, unknown ones reload all of them
+			var environment string
+			if envVar, ok := mux.Vars(r)["env"]; ok {
+				if env, err := envs.GetCtx(r.Context(), envVar); err == nil {
+					environment = env.UUID
+				}
+			}
+			publishEvent(context.Background(), kind, environment, mux.Vars(r)["name"])
+		}
+		w.WriteHeader(rec.status)
+		if _, err := w.Write(rec.body.Bytes()); err != nil {
+			log.Printf("error sending response %v", err)
+		}
+	})
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+const (
+	metricAPIAccessReq = "access-req"
+	metricAPIAccessErr = "access-err"
+	metricAPIAccessOK  = "access-ok"
+)
+
+// APIAccessLogResponse to return one page of the data access log
+type APIAccessLogResponse struct {
+	Total   int64                     `json:"total"`
+	Page    int                       `json:"page"`
+	Limit   int                       `json:"limit"`
+	Entries []environments.DataAccess `json:"entries"`
+}
+
+// Helper to record a download of the API in the data access log, ranged requests resuming it are not recorded
+func recordAccess(r *http.Request, envID uint, entityType, entity, detail string, size int64) {
+	if !environments.LogicalDownload(r.Header.Get("Range")) {
+		return
+	}
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	via := environments.AccessViaToken
+	if ctx[ctxKeyID] != "" {
+		via = environments.AccessViaKey
+	}
+	envs.RecordAccess(environments.DataAccess{
+		EnvironmentID: envID,
+		Actor:         ctx[ctxUser],
+		Via:           via,
+		EntityType:    entityType,
+		Entity:        entity,
+		Detail:        detail,
+		Bytes:         size,
+		IPAddress:     utils.GetIP(r),
+	})
+}
+
+// GET Handler to return the data access log as JSON or ?format=csv, filtered with ?env=&user=&entity_type=&entity=&from=&to=
+func apiAccessLogHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIAccessReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.AdminLevel, users.NoEnvironment) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPIAccessErr)
+		return
+	}
+	q := r.URL.Query()
+	filter, err := environments.ParseAccessFilter(q, time.Now())
+	if err != nil {
+		apiErrorResponse(w, "invalid access filter", http.StatusBadRequest, err)
+		incMetric(metricAPIAccessErr)
+		return
+	}
+	envAll, err := envs.AllCtx(r.Context())
+	if err != nil {
+		apiErrorResponse(w, "error getting environments", http.StatusInternalServerError, err)
+		incMetric(metricAPIAccessErr)
+		return
+	}
+	envNames := make(map[uint]string, len(envAll))
+	for _, e := range envAll {
+		envNames[e.ID] = e.Name
+		if envVar := q.Get("env"); envVar != "" && (e.Name == envVar || e.UUID == envVar) {
+			filter.EnvironmentIDs = []uint{e.ID}
+		}
+	}
+	if q.Get("env") != "" && filter.EnvironmentIDs == nil {
+		apiErrorResponse(w, "environment not found", http.StatusNotFound, fmt.Errorf("environment %s", q.Get("env")))
+		incMetric(metricAPIAccessErr)
+		return
+	}
+	if q.Get("format") == "csv" {
+		filter.Page = 1
+		filter.Limit = environments.MaxAccessLimit
+	}
+	entries, total, err := envs.AccessLog(filter)
+	if err != nil {
+		apiErrorResponse(w, "error getting data access log", http.StatusInternalServerError, err)
+		incMetric(metricAPIAccessErr)
+		return
+	}
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned %d data accesses", len(entries))
+	}
+	if q.Get("format") != "csv" {
+		if entries == nil {
+			entries = []environments.DataAccess{}
+		}
+		utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, APIAccessLogResponse{
+			Total:   total,
+			Page:    filter.Page,
+			Limit:   filter.Limit,
+			Entries: entries,
+		})
+		incMetric(metricAPIAccessOK)
+		return
+	}
+	// Exports of the report are downloads of who downloaded, so they are recorded too
+	filename := fmt.Sprintf("access-log-%s.csv", time.Now().UTC().Format("20060102-150405"))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Header().Set(utils.ContentType, "text/csv")
+	w.WriteHeader(http.StatusOK)
+	counter := &environments.AccessCounter{ResponseWriter: w}
+	cw := csv.NewWriter(counter)
+	_ = cw.Write([]string{"timestamp", "environment", "actor", "via", "entity_type", "entity", "detail", "bytes", "ip_address"})
+	for _, a := range entries {
+		_ = cw.Write([]string{
+			a.CreatedAt.UTC().Format(time.RFC3339),
+			envNames[a.EnvironmentID],
+			a.Actor,
+			a.Via,
+			a.EntityType,
+			a.Entity,
+			a.Detail,
+			strconv.FormatInt(a.Bytes, 10),
+			a.IPAddress,
+		})
+	}
+	cw.Flush()
+	recordAccess(r, 0, environments.AccessReport, filename, r.URL.RawQuery, counter.Bytes)
+	incMetric(metricAPIAccessOK)
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	metricAPIActivityReq = "activity-req"
+	metricAPIActivityErr = "activity-err"
+	metricAPIActivityOK  = "activity-ok"
+)
+
+// APIActivityDiff to return one entry of the activity of an environment with its differences
+type APIActivityDiff struct {
+	types.ApiActivityEntry
+	Before string                  `json:"before"`
+	After  string                  `json:"after"`
+	Diff   []environments.DiffLine `json:"diff"`
+}
+
+// Helper to convert an entry of activity for API responses
+func apiActivityEntry(a environments.EnvActivity) types.ApiActivityEntry {
+	return types.ApiActivityEntry{
+		ID:        a.ID,
+		CreatedAt: a.CreatedAt,
+		Kind:      a.Kind,
+		Actor:     a.Actor,
+		Summary:   a.Summary,
+		Changed:   a.Changed,
+	}
+}
+
+// GET Handler to return the activity of one environment as JSON, filtered with from, to, kind, actor, page and limit
+func apiActivityHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIActivityReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPIActivityErr)
+		return
+	}
+	// Get environment by name
+	env, err := envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		if err.Error() == "record not found" {
+			apiErrorResponse(w, "environment not found", http.StatusNotFound, err)
+		} else {
+			apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, err)
+		}
+		incMetric(metricAPIActivityErr)
+		return
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.UserLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPIActivityErr)
+		return
+	}
+	filter, err := environments.ParseActivityFilter(r.URL.Query(), time.Now())
+	if err != nil {
+		apiErrorResponse(w, "invalid activity filter", http.StatusBadRequest, err)
+		incMetric(metricAPIActivityErr)
+		return
+	}
+	entries, total, err := envs.Activity(env.ID, filter)
+	if err != nil {
+		apiErrorResponse(w, "error getting activity", http.StatusInternalServerError, err)
+		incMetric(metricAPIActivityErr)
+		return
+	}
+	res := types.ApiActivityResponse{
+		Total:   total,
+		Page:    filter.Page,
+		Limit:   filter.Limit,
+		Entries: []types.ApiActivityEntry{},
+	}
+	for _, a := range entries {
+		res.Entries = append(res.Entries, apiActivityEntry(a))
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned activity for %s", env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, res)
+	incMetric(metricAPIActivityOK)
+}
+
+// GET Handler to return one entry of the activity of one environment with its differences as JSON
+func apiActivityDiffHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIActivityReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPIActivityErr)
+		return
+	}
+	// Extract id
+	id, err := strconv.ParseUint(vars["id"], 10, 64)
+	if err != nil {
+		apiErrorResponse(w, "invalid activity id", http.StatusBadRequest, err)
+		incMetric(metricAPIActivityErr)
+		return
+	}
+	// Get environment by name
+	env, err := envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		if err.Error() == "record not found" {
+			apiErrorResponse(w, "environment not found", http.StatusNotFound, err)
+		} else {
+			apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, err)
+		}
+		incMetric(metricAPIActivityErr)
+		return
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.UserLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPIActivityErr)
+		return
+	}
+	a, err := envs.GetActivity(env.ID, uint(id))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			apiErrorResponse(w, "activity not found", http.StatusNotFound, err)
+		} else {
+			apiErrorResponse(w, "error getting activity", http.StatusInternalServerError, err)
+		}
+		incMetric(metricAPIActivityErr)
+		return
+	}
+	res := APIActivityDiff{
+		ApiActivityEntry: apiActivityEntry(a),
+		Before:           a.Before,
+		After:            a.After,
+		Diff:             environments.DiffLines(a.Before, a.After),
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned activity %d for %s", a.ID, env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, res)
+	incMetric(metricAPIActivityOK)
+}
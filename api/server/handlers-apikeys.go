@@ -0,0 +1,133 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+const (
+	metricAPIKeysReq = "apikeys-req"
+	metricAPIKeysErr = "apikeys-err"
+	metricAPIKeysOK  = "apikeys-ok"
+)
+
+// GET Handler to return the service keys of one environment as JSON, keys themselves are never returned
+func apiKeysHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIKeysReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, _, ok := envWithAccess(w, r, users.AdminLevel)
+	if !ok {
+		incMetric(metricAPIKeysErr)
+		return
+	}
+	keys, err := apiUsers.APIKeys(env.UUID)
+	if err != nil {
+		apiErrorResponse(w, "error getting keys", http.StatusInternalServerError, err)
+		incMetric(metricAPIKeysErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned service keys for %s", env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, keys)
+	incMetric(metricAPIKeysOK)
+}
+
+// POST Handler to create or rotate a service key of one environment, the response is the only time the key is visible
+func apiNewKeyHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIKeysReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, actor, ok := envWithAccess(w, r, users.AdminLevel)
+	if !ok {
+		incMetric(metricAPIKeysErr)
+		return
+	}
+	var k types.ApiKeyRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&k); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPIKeysErr)
+		return
+	}
+	expires := users.APIKeyExpiration(k.Days, time.Now())
+	var key users.APIKey
+	var token, msg string
+	var err error
+	if k.Rotate {
+		overlap := users.DefaultAPIKeyOverlap
+		if k.Overlap > 0 {
+			overlap = time.Duration(k.Overlap) * time.Hour
+		}
+		key, token, err = apiUsers.RotateAPIKey(k.Name, env.UUID, expires, overlap, actor)
+		msg = fmt.Sprintf("service key %s rotated, previous keys expire in %s", k.Name, overlap)
+	} else {
+		scopes, serr := users.ParseScopes(k.Scopes)
+		if serr != nil {
+			apiErrorResponse(w, "invalid scopes", http.StatusBadRequest, serr)
+			incMetric(metricAPIKeysErr)
+			return
+		}
+		key, token, err = apiUsers.NewAPIKey(k.Name, env.UUID, scopes, expires, actor)
+		msg = fmt.Sprintf("service key %s created with scopes %s", k.Name, strings.Join(scopes, ","))
+	}
+	if err != nil {
+		apiErrorResponse(w, "error saving key", http.StatusBadRequest, err)
+		incMetric(metricAPIKeysErr)
+		return
+	}
+	recordActivity(env, environments.ActivityAPIKeys, actor, msg, "", "")
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: %s for %s", msg, env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiKeyResponse{
+		Name:      key.Name,
+		KeyID:     key.KeyID,
+		Scopes:    key.Scopes,
+		ExpiresAt: key.ExpiresAt,
+		Key:       token,
+	})
+	incMetric(metricAPIKeysOK)
+}
+
+// POST Handler to revoke a service key of one environment
+func apiRevokeKeyHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIKeysReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, actor, ok := envWithAccess(w, r, users.AdminLevel)
+	if !ok {
+		incMetric(metricAPIKeysErr)
+		return
+	}
+	var k types.ApiKeyRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&k); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPIKeysErr)
+		return
+	}
+	if err := apiUsers.RevokeAPIKey(k.KeyID, env.UUID); err != nil {
+		apiErrorResponse(w, "error revoking key", http.StatusNotFound, err)
+		incMetric(metricAPIKeysErr)
+		return
+	}
+	// Return message as serialized response
+	msg := fmt.Sprintf("service key %s revoked", k.KeyID)
+	recordActivity(env, environments.ActivityAPIKeys, actor, msg, "", "")
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: %s for %s", msg, env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiGenericResponse{Message: msg})
+	incMetric(metricAPIKeysOK)
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/queries"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+const (
+	// Header with the SHA-256 of archived objects
+	archiveHashHeader = "X-Archive-SHA256"
+)
+
+// Helper to get the policy to archive queries from settings
+func apiArchivePolicy() queries.ArchivePolicy {
+	return queries.NewArchivePolicy(settingsmgr.QueryArchiveDays(), settingsmgr.ArchiveExecutions(), settingsmgr.RehydrateHours())
+}
+
+// Helper to record the changes of archives made in the background in the activity of their environment
+func recordArchive(a queries.QueryArchive) {
+	actor := queries.ArchiveActor
+	if a.State == queries.ArchiveRehydrated || a.Error != "" {
+		actor = a.RehydratedBy
+	}
+	if err := envs.RecordActivity(a.EnvironmentID, environments.ActivityArchives, actor, queries.ArchiveSummary(a), "", ""); err != nil {
+		log.Printf("error recording activity for archive of %s %v", a.Name, err)
+	}
+}
+
+// Helper to get the archive of a query from the request, if the user can see its results
+func archiveFromRequest(w http.ResponseWriter, r *http.Request, level users.AccessLevel) (environments.TLSEnvironment, queries.QueryArchive, string, bool) {
+	env, user, ok := envWithAccess(w, r, level)
+	if !ok {
+		return env, queries.QueryArchive{}, "", false
+	}
+	name := mux.Vars(r)["name"]
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !apiCanViewName(r.Context(), ctx, name, env.ID) {
+		apiErrorResponse(w, "query results are restricted", http.StatusForbidden, fmt.Errorf("attempt to see archive of %s by user %s", name, user))
+		return env, queries.QueryArchive{}, user, false
+	}
+	archive, err := queriesmgr.GetArchive(name, env.ID)
+	if err != nil {
+		apiErrorResponse(w, "query is not archived", http.StatusNotFound, err)
+		return env, archive, user, false
+	}
+	return env, archive, user, true
+}
+
+// Helper to reject requests for results of archived queries that are not rehydrated, returning true if rejected
+func archivedResults(w http.ResponseWriter, name string, envid uint) bool {
+	archive, err := queriesmgr.GetArchive(name, envid)
+	if err != nil || archive.Available() {
+		return false
+	}
+	apiErrorResponse(w, "query is archived, rehydrate it to get the results", http.StatusConflict, fmt.Errorf("query %s is %s", name, archive.State))
+	return true
+}
+
+// GET Handler to return the archive of a query, with the rehydration progress
+func apiQueryArchiveHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIQueriesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	_, archive, _, ok := archiveFromRequest(w, r, users.QueryLevel)
+	if !ok {
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned archive of %s", archive.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, archive)
+	incMetric(metricAPIQueriesOK)
+}
+
+// POST Handler to request the results of an archived query to be rehydrated, it happens in the background
+func apiQueryRehydrateHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIQueriesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, archive, user, ok := archiveFromRequest(w, r, users.QueryLevel)
+	if !ok {
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	archive, err := queriesmgr.RequestRehydrate(archive.Name, env.ID, user)
+	if err != nil {
+		apiErrorResponse(w, "error rehydrating query", http.StatusConflict, err)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	recordActivity(env, environments.ActivityArchives, user, queries.ArchiveSummary(archive), "", "")
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Rehydration of %s requested", archive.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusAccepted, archive)
+	incMetric(metricAPIQueriesOK)
+}
+
+// GET Handler to download the archived results of a query as compressed lines of JSON, read directly from the archive
+func apiQueryArchiveDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIQueriesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, archive, _, ok := archiveFromRequest(w, r, users.QueryLevel)
+	if !ok {
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	if archive.Storage != archiveStore.Name() {
+		apiErrorResponse(w, "archive is not available", http.StatusGone, fmt.Errorf("%s is stored in %s", archive.Name, archive.Storage))
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	object, err := archiveStore.Open(r.Context(), archive.Location, archive.Size)
+	if err != nil {
+		apiErrorResponse(w, "archive is not available", http.StatusGone, err)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	defer object.Close()
+	filename := fmt.Sprintf("%s-results.jsonl.gz", archive.Name)
+	w.Header().Set(utils.ContentType, "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Header().Set("ETag", fmt.Sprintf("%q", archive.SHA256))
+	w.Header().Set(archiveHashHeader, archive.SHA256)
+	// Downloads resumed in ranges are recorded once, with the size of the whole archive
+	recordAccess(r, env.ID, environments.AccessQueryResult, archive.Name, "archived results", archive.Size)
+	http.ServeContent(w, r, filename, archive.CreatedAt, object)
+	incMetric(metricAPIQueriesOK)
+}
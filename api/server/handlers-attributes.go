@@ -0,0 +1,210 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+// Helper to check which namespaces of attributes the user or the service key of a request can write.
+// Service keys need the attributes scope for the namespace, users need to be admins of the environment.
+func attributesAccess(ctx contextValue) func(namespace string) bool {
+	return func(namespace string) bool {
+		if ctx[ctxKeyID] == "" {
+			return true
+		}
+		key := users.APIKey{Scopes: ctx[ctxKeyScopes]}
+		return key.HasNamespace(users.ScopeAttributesWrite, namespace)
+	}
+}
+
+// Helper to get a node of an environment by the identifier in the route
+func nodeOfEnv(w http.ResponseWriter, r *http.Request, env environments.TLSEnvironment) (nodes.OsqueryNode, bool) {
+	nodeVar := mux.Vars(r)["node"]
+	node, err := nodesmgr.GetByIdentifierCtx(r.Context(), nodeVar)
+	if err != nil {
+		if err.Error() == "record not found" {
+			apiErrorResponse(w, "node not found", http.StatusNotFound, err)
+		} else {
+			apiErrorResponse(w, "error getting node", http.StatusInternalServerError, err)
+		}
+		return node, false
+	}
+	if node.EnvironmentID != env.ID {
+		apiErrorResponse(w, "node not found", http.StatusNotFound, fmt.Errorf("node %s not in environment %s", nodeVar, env.Name))
+		return node, false
+	}
+	return node, true
+}
+
+// GET Handler for the custom attributes of a node
+func apiNodeAttributesHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPINodesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, _, ok := envWithAccess(w, r, users.UserLevel)
+	if !ok {
+		incMetric(metricAPINodesErr)
+		return
+	}
+	node, ok := nodeOfEnv(w, r, env)
+	if !ok {
+		incMetric(metricAPINodesErr)
+		return
+	}
+	attrs, err := nodesmgr.GetAttributes(node.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting attributes", http.StatusInternalServerError, err)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned attributes of node %s", node.UUID)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, attrs)
+	incMetric(metricAPINodesOK)
+}
+
+// PUT Handler to change the custom attributes of a node, null values delete them
+func apiSetNodeAttributesHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPINodesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, actor, ok := envWithAccess(w, r, users.AdminLevel)
+	if !ok {
+		incMetric(metricAPINodesErr)
+		return
+	}
+	node, ok := nodeOfEnv(w, r, env)
+	if !ok {
+		incMetric(metricAPINodesErr)
+		return
+	}
+	var a types.ApiNodeAttributesRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+		apiErrorResponse(w, "error parsing PUT body", http.StatusBadRequest, err)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	attrs, err := nodesmgr.SetAttributes(node, a.Attributes, actor, attributesAccess(ctx), settingsmgr.AttributesMaxBytes())
+	if err != nil {
+		apiErrorResponse(w, "error setting attributes", http.StatusBadRequest, err)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Attributes of node %s changed by %s", node.UUID, actor)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, attrs)
+	incMetric(metricAPINodesOK)
+}
+
+// GET Handler to export the custom attributes of all the nodes of an environment, by node UUID
+func apiEnvAttributesHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPINodesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, _, ok := envWithAccess(w, r, users.UserLevel)
+	if !ok {
+		incMetric(metricAPINodesErr)
+		return
+	}
+	nds, err := nodesmgr.GetByEnv(env.Name, "all", 0)
+	if err != nil {
+		apiErrorResponse(w, "error getting nodes", http.StatusInternalServerError, err)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	attrs, err := nodesmgr.AttributesOfNodes(nds)
+	if err != nil {
+		apiErrorResponse(w, "error getting attributes", http.StatusInternalServerError, err)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	byUUID := make(map[string][]nodes.NodeAttribute)
+	for _, n := range nds {
+		if len(attrs[n.ID]) > 0 {
+			byUUID[n.UUID] = attrs[n.ID]
+		}
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned attributes of environment %s", env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, byUUID)
+	incMetric(metricAPINodesOK)
+}
+
+// PUT Handler to change the custom attributes of many nodes, nodes are changed independently
+func apiBulkAttributesHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPINodesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, actor, ok := envWithAccess(w, r, users.AdminLevel)
+	if !ok {
+		incMetric(metricAPINodesErr)
+		return
+	}
+	var b types.ApiBulkAttributesRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+		apiErrorResponse(w, "error parsing PUT body", http.StatusBadRequest, err)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	allowed := attributesAccess(ctx)
+	maxBytes := settingsmgr.AttributesMaxBytes()
+	res := types.ApiBulkAttributesResponse{Errors: make(map[string]string)}
+	for uuid, changes := range b.Nodes {
+		node, err := nodesmgr.GetByUUIDEnv(uuid, env.ID)
+		if err != nil {
+			res.Errors[uuid] = "node not found"
+			continue
+		}
+		if _, err := nodesmgr.SetAttributes(node, changes, actor, allowed, maxBytes); err != nil {
+			res.Errors[uuid] = err.Error()
+			continue
+		}
+		res.Updated++
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Attributes of %d nodes changed by %s", res.Updated, actor)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, res)
+	incMetric(metricAPINodesOK)
+}
+
+// DELETE Handler to delete the custom attributes of a namespace from all nodes
+func apiDeleteAttributeNamespaceHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPINodesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.AdminLevel, users.NoEnvironment) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPINodesErr)
+		return
+	}
+	namespace := strings.ToLower(mux.Vars(r)["namespace"])
+	deleted, err := nodesmgr.DeleteAttributeNamespace(namespace)
+	if err != nil {
+		apiErrorResponse(w, "error deleting attributes", http.StatusBadRequest, err)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	log.Printf("%d attributes of namespace %s deleted by %s", deleted, namespace, ctx[ctxUser])
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiGenericResponse{Message: fmt.Sprintf("%d attributes of namespace %s deleted", deleted, namespace)})
+	incMetric(metricAPINodesOK)
+}
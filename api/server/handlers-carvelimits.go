@@ -0,0 +1,145 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jmpsec/osctrl/carves"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+// Helper to check the path of a new carve against the denied paths of the environment. Admins can carve a denied
+// path with a justification, returning the override to keep once the carve exists. Rejections are audited.
+func carvePathAllowed(env environments.TLSEnvironment, ctx contextValue, path, justification string) (*carves.CarvePathOverride, string, error) {
+	limits, err := filecarves.GetLimits(env.ID)
+	if err != nil {
+		return nil, "", err
+	}
+	pattern, denied := limits.PathDenied(path)
+	if !denied {
+		return nil, "", nil
+	}
+	if justification != "" && checkAccess(ctx, users.AdminLevel, env.UUID) {
+		return &carves.CarvePathOverride{EnvironmentID: env.ID, Path: path, Justification: justification, User: ctx[ctxUser]}, pattern, nil
+	}
+	reason := fmt.Errorf("path %s is denied by %s, admins can carve it with a justification", path, pattern)
+	rejection := carves.CarveRejection{
+		EnvironmentID: env.ID,
+		Limit:         carves.LimitPath,
+		Path:          path,
+		Requester:     ctx[ctxUser],
+		Detail:        reason.Error(),
+	}
+	if err := filecarves.RecordRejection(rejection); err != nil {
+		log.Printf("error recording carve rejection %v", err)
+	}
+	return nil, pattern, reason
+}
+
+// GET Handler to return the carve limits of an environment with the current use against them
+func apiCarveLimitsHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPICarvesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, _, ok := envWithAccess(w, r, users.CarveLevel)
+	if !ok {
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	usage, err := filecarves.Usage(env.ID, time.Now())
+	if err != nil {
+		apiErrorResponse(w, "error getting carve usage", http.StatusInternalServerError, err)
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned carve limits of %s", env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, usage)
+	incMetric(metricAPICarvesOK)
+}
+
+// POST Handler to set the carve limits of an environment
+func apiSetCarveLimitsHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPICarvesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, actor, ok := envWithAccess(w, r, users.AdminLevel)
+	if !ok {
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	var l types.ApiCarveLimitsRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&l); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	before, err := filecarves.GetLimits(env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting carve limits", http.StatusInternalServerError, err)
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	limits := carves.CarveLimits{
+		MaxCarveBytes:   l.MaxBytes,
+		MaxNodeSessions: l.MaxNodeSessions,
+		MaxEnvSessions:  l.MaxEnvSessions,
+		DailyBytes:      l.DailyBytes,
+		DeniedPaths:     strings.Join(l.DeniedPaths, "\n"),
+	}
+	limits, err = filecarves.SetLimits(env.ID, limits, actor)
+	if err != nil {
+		apiErrorResponse(w, "error setting carve limits", http.StatusBadRequest, err)
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	recordActivity(env, environments.ActivityCarves, actor, "carve limits updated", carves.LimitsSummary(before), carves.LimitsSummary(limits))
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Carve limits of %s updated", env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, limits)
+	incMetric(metricAPICarvesOK)
+}
+
+// GET Handler to return the carves rejected by the limits of an environment
+func apiCarveRejectionsHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPICarvesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, _, ok := envWithAccess(w, r, users.CarveLevel)
+	if !ok {
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	now := time.Now()
+	since, err := environments.ParseSince(r.URL.Query().Get("since"), now)
+	if err != nil {
+		apiErrorResponse(w, "invalid since", http.StatusBadRequest, err)
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	if since.IsZero() {
+		since, _ = carves.CarveWindow(now)
+	}
+	rejections, err := filecarves.Rejections(env.ID, since)
+	if err != nil {
+		apiErrorResponse(w, "error getting carve rejections", http.StatusInternalServerError, err)
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned carve rejections of %s", env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, rejections)
+	incMetric(metricAPICarvesOK)
+}
@@ -0,0 +1,533 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/admin/sessions"
+	"github.com/jmpsec/osctrl/carves"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/queries"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+const (
+	metricAPICarvesReq = "carves-req"
+	metricAPICarvesErr = "carves-err"
+	metricAPICarvesOK  = "carves-ok"
+)
+
+// GET Handler to return a single carve in JSON
+func apiCarveShowHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPICarvesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	vars := mux.Vars(r)
+	// Extract name
+	name, ok := vars["name"]
+	if !ok {
+		apiErrorResponse(w, "error getting name", http.StatusInternalServerError, nil)
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		apiErrorResponse(w, "error with environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	// Get environment
+	env, err := envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.CarveLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	// Get carve by name
+	carve, err := filecarves.GetByQueryCtx(r.Context(), name, env.ID)
+	if err != nil {
+		if err.Error() == "record not found" {
+			apiErrorResponse(w, "carve not found", http.StatusNotFound, err)
+		} else {
+			apiErrorResponse(w, "error getting carve", http.StatusInternalServerError, err)
+		}
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	// Carved files of restricted carves are only for who can see them
+	if !apiCanViewName(r.Context(), ctx, name, env.ID) {
+		apiErrorResponse(w, "carve is restricted", http.StatusForbidden, fmt.Errorf("attempt to see carve %s by user %s", name, ctx[ctxUser]))
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned carve %s", name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, carve)
+	incMetric(metricAPICarvesOK)
+}
+
+// POST Handler to run a carve
+func apiCarvesRunHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPICarvesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		apiErrorResponse(w, "error with environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	// Get environment
+	env, err := envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.CarveLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	var c types.ApiDistributedCarveRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	// Critical notices of the environment must be confirmed
+	if err := apiUsers.CheckConfirmation(env.UUID, c.ConfirmNotices); err != nil {
+		apiErrorResponse(w, err.Error(), http.StatusConflict, nil)
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	// Launches over many targets need the name of the environment typed, by the policy of its category
+	broad := len(c.Attributes) > 0 || c.EnrolledAfter != "" || c.EnrolledBefore != "" || c.SeenAfter != "" || c.SeenBefore != ""
+	if err := checkLaunch(env, c.UUID, broad, c.ConfirmName); errors.Is(err, environments.ErrConfirmName) {
+		apiErrorResponse(w, err.Error(), http.StatusPreconditionRequired, nil)
+		incMetric(metricAPICarvesErr)
+		return
+	} else if err != nil {
+		apiErrorResponse(w, "error getting targets", http.StatusInternalServerError, err)
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	// Path can not be empty
+	if c.Path == "" {
+		apiErrorResponse(w, "path can not be empty", http.StatusInternalServerError, nil)
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	// New carves are blocked while the storage quota of the environment is exceeded
+	quota, err := envs.GetStorageQuota(env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting storage quota", http.StatusInternalServerError, err)
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	if quota.Active(environments.QuotaBlockCarves) {
+		apiErrorResponse(w, "storage quota exceeded, new carves are blocked", http.StatusInsufficientStorage, nil)
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	// Denied paths are only carved by admins with a justification
+	override, pattern, err := carvePathAllowed(env, ctx, c.Path, c.Justification)
+	if err != nil {
+		apiErrorResponse(w, err.Error(), http.StatusForbidden, nil)
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	window, err := queries.ParseTargetWindow(c.EnrolledAfter, c.EnrolledBefore, c.SeenAfter, c.SeenBefore)
+	if err != nil {
+		apiErrorResponse(w, "invalid time window", http.StatusBadRequest, err)
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	attrFilters, err := queries.ParseAttributeTargets(c.Attributes)
+	if err != nil {
+		apiErrorResponse(w, "invalid attributes target", http.StatusBadRequest, err)
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	if err := queries.ValidateVisibility(c.Visibility, c.VisibilityTeam); err != nil {
+		apiErrorResponse(w, "invalid visibility", http.StatusBadRequest, err)
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	query := carves.GenCarveQuery(c.Path, false)
+	// Prepare and create new carve
+	carveName := carves.GenCarveName()
+	newQuery := queries.DistributedQuery{
+		Query:          query,
+		Name:           carveName,
+		Creator:        ctx[sessions.CtxUser],
+		Expected:       0,
+		Executions:     0,
+		Active:         true,
+		Completed:      false,
+		Deleted:        false,
+		Type:           queries.CarveQueryType,
+		Path:           c.Path,
+		EnvironmentID:  env.ID,
+		Visibility:     c.Visibility,
+		VisibilityTeam: c.VisibilityTeam,
+	}
+	if err := queriesmgr.Create(newQuery); err != nil {
+		apiErrorResponse(w, "error creating query", http.StatusInternalServerError, err)
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	if override != nil {
+		override.QueryName = carveName
+		if err := filecarves.AddPathOverride(*override); err != nil {
+			apiErrorResponse(w, "error allowing carve of denied path", http.StatusInternalServerError, err)
+			incMetric(metricAPICarvesErr)
+			return
+		}
+		recordActivity(env, environments.ActivityCarves, ctx[ctxUser], carves.PathOverrideSummary(*override, pattern), "", "")
+	}
+	// Create UUID target
+	if (c.UUID != "") && nodesmgr.CheckByUUID(c.UUID) {
+		if err := queriesmgr.CreateTarget(carveName, queries.QueryTargetUUID, c.UUID); err != nil {
+			apiErrorResponse(w, "error creating carve UUID target", http.StatusInternalServerError, err)
+			incMetric(metricAPICarvesErr)
+			return
+		}
+	}
+	// Create custom attributes targets
+	attrTargets, err := queriesmgr.CreateAttributeTargets(carveName, attrFilters)
+	if err != nil {
+		apiErrorResponse(w, "error creating carve attributes target", http.StatusInternalServerError, err)
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	// Create time window targets
+	if err := queriesmgr.CreateWindowTargets(carveName, window); err != nil {
+		apiErrorResponse(w, "error creating carve time window targets", http.StatusInternalServerError, err)
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	// Materialize the carve for the targeted nodes
+	targeted, err := queriesmgr.Materialize(carveName, env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error materializing carve", http.StatusInternalServerError, err)
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	// Nodes within a time window or by attributes are the ones materialized
+	expected := 1
+	if !window.Empty() || attrTargets > 0 {
+		expected = targeted
+	}
+	// Update value for expected
+	if err := queriesmgr.SetExpected(carveName, expected, env.ID); err != nil {
+		apiErrorResponse(w, "error setting expected", http.StatusInternalServerError, err)
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	// Return query name as serialized response
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiQueriesResponse{Name: newQuery.Name})
+	incMetric(metricAPICarvesOK)
+}
+
+// GET Handler to return carves in JSON
+func apiCarvesShowHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPICarvesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		apiErrorResponse(w, "error with environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	// Get environment
+	env, err := envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.CarveLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	// Get carves
+	all, err := filecarves.GetByEnvCtx(r.Context(), env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting carves", http.StatusInternalServerError, err)
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	// Carved files of restricted carves are left out for who can not see them
+	hidden, err := queriesmgr.HiddenNamesCtx(r.Context(), env.ID, apiViewer(ctx))
+	if err != nil {
+		apiErrorResponse(w, "error getting carves", http.StatusInternalServerError, err)
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	carves := all[:0]
+	for _, c := range all {
+		if !hidden[c.QueryName] {
+			carves = append(carves, c)
+		}
+	}
+	if len(carves) == 0 {
+		apiErrorResponse(w, "no carves", http.StatusNotFound, nil)
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	// Serialize and serve JSON
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, carves)
+	incMetric(metricAPICarvesOK)
+}
+
+// Helper to get the environment and the carve of a request by session, checking access
+func carveSession(w http.ResponseWriter, r *http.Request) (environments.TLSEnvironment, carves.CarvedFile, bool) {
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		apiErrorResponse(w, "error with environment", http.StatusInternalServerError, nil)
+		return environments.TLSEnvironment{}, carves.CarvedFile{}, false
+	}
+	// Extract session
+	sessionid, ok := vars["sessionid"]
+	if !ok {
+		apiErrorResponse(w, "error getting session", http.StatusInternalServerError, nil)
+		return environments.TLSEnvironment{}, carves.CarvedFile{}, false
+	}
+	// Get environment
+	env, err := envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		if err.Error() == "record not found" {
+			apiErrorResponse(w, "environment not found", http.StatusNotFound, err)
+		} else {
+			apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, err)
+		}
+		return env, carves.CarvedFile{}, false
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.CarveLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		return env, carves.CarvedFile{}, false
+	}
+	// Get carve by session
+	carve, err := filecarves.GetBySessionCtx(r.Context(), sessionid)
+	if err != nil {
+		apiErrorResponse(w, "error getting carve", http.StatusInternalServerError, err)
+		return env, carve, false
+	}
+	if carve.ID == 0 || carve.EnvironmentID != env.ID {
+		apiErrorResponse(w, "carve not found", http.StatusNotFound, nil)
+		return env, carve, false
+	}
+	// Carved files of restricted carves are only for who can see them
+	if !apiCanViewName(r.Context(), ctx, carve.QueryName, env.ID) {
+		apiErrorResponse(w, "carve is restricted", http.StatusForbidden, fmt.Errorf("attempt to see carve %s by user %s", carve.QueryName, ctx[ctxUser]))
+		return env, carve, false
+	}
+	return env, carve, true
+}
+
+// GET Handler to download the content of a carve, when the scan policy of the environment allows it
+func apiCarveDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPICarvesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, carve, ok := carveSession(w, r)
+	if !ok {
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	if carve.Status != carves.StatusCompleted {
+		apiErrorResponse(w, "carve is not completed", http.StatusConflict, nil)
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	switch carves.DownloadDecision(env.CarveScanPolicy, carve.ScanVerdict) {
+	case carves.DownloadBlock:
+		// Only administrators can override the policy, and the override is recorded
+		if r.URL.Query().Get("override") != "true" || !checkAccess(ctx, users.AdminLevel, env.UUID) {
+			apiErrorResponse(w, "download blocked by scan policy", http.StatusForbidden, fmt.Errorf("carve %s verdict %q", carve.CarveID, carve.ScanVerdict))
+			incMetric(metricAPICarvesErr)
+			return
+		}
+		recordActivity(env, environments.ActivityCarves, ctx[ctxUser], carves.OverrideSummary(carve), "", "")
+	case carves.DownloadWarn:
+		log.Printf("carve %s is %s and downloaded by %s - %s", carve.CarveID, carve.ScanVerdict, ctx[ctxUser], carve.ScanDetail)
+	}
+	content, err := filecarves.Content(carve)
+	if err != nil {
+		apiErrorResponse(w, "error getting carve content", http.StatusInternalServerError, err)
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	w.Header().Set(utils.ContentType, "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", carves.GenerateArchiveName(carve)))
+	w.Header().Set("X-Osctrl-Scan-Verdict", carve.ScanVerdict)
+	w.WriteHeader(http.StatusOK)
+	counter := &environments.AccessCounter{ResponseWriter: w}
+	if _, err := io.Copy(counter, content); err != nil {
+		log.Printf("error downloading carve %s - %v", carve.CarveID, err)
+	}
+	recordAccess(r, env.ID, environments.AccessCarve, carve.QueryName, carve.SessionID, counter.Bytes)
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Downloaded carve %s", carve.CarveID)
+	}
+	incMetric(metricAPICarvesOK)
+}
+
+// POST Handler to scan again a completed carve, the verdict is pending until the scanner returns
+func apiCarveScanHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPICarvesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	_, carve, ok := carveSession(w, r)
+	if !ok {
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	scanner := carves.ScannerFromSettings(settingsmgr)
+	if scanner == nil {
+		apiErrorResponse(w, "no carve scanner configured", http.StatusBadRequest, nil)
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	if err := filecarves.StartScan(scanner, carve.SessionID); err != nil {
+		apiErrorResponse(w, "error scanning carve", http.StatusBadRequest, err)
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Scanning carve %s", carve.CarveID)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusAccepted, types.ApiGenericResponse{Message: "scan of carve " + carve.CarveID + " started"})
+	incMetric(metricAPICarvesOK)
+}
+
+// GET Handler to get the manifest of a carve, or with ?path= to extract and download one file of the carve.
+// Extracting files is recorded in the activity of the environment.
+func apiCarveFilesHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPICarvesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, carve, ok := carveSession(w, r)
+	if !ok {
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	manifest, err := carves.ManifestOf(carve)
+	if err != nil {
+		apiErrorResponse(w, "error getting manifest", http.StatusInternalServerError, err)
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	filePath := r.URL.Query().Get("path")
+	if filePath == "" {
+		// Serialize and serve JSON
+		if settingsmgr.DebugService(settings.ServiceAPI) {
+			log.Printf("DebugService: Returned manifest of carve %s", carve.CarveID)
+		}
+		utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, manifest)
+		incMetric(metricAPICarvesOK)
+		return
+	}
+	if manifest.Status != carves.ManifestReady {
+		apiErrorResponse(w, "carve has no manifest, download the whole carve", http.StatusConflict, nil)
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	entry, ok := carves.FindEntry(manifest.Entries, filePath)
+	if !ok || entry.Type != carves.EntryFile {
+		apiErrorResponse(w, "file not found in carve", http.StatusNotFound, nil)
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	// Files inside a carve follow the scan policy of the whole carve
+	switch carves.DownloadDecision(env.CarveScanPolicy, carve.ScanVerdict) {
+	case carves.DownloadBlock:
+		if r.URL.Query().Get("override") != "true" || !checkAccess(ctx, users.AdminLevel, env.UUID) {
+			apiErrorResponse(w, "download blocked by scan policy", http.StatusForbidden, fmt.Errorf("carve %s verdict %q", carve.CarveID, carve.ScanVerdict))
+			incMetric(metricAPICarvesErr)
+			return
+		}
+		recordActivity(env, environments.ActivityCarves, ctx[ctxUser], carves.OverrideSummary(carve), "", "")
+	case carves.DownloadWarn:
+		log.Printf("carve %s is %s and downloaded by %s - %s", carve.CarveID, carve.ScanVerdict, ctx[ctxUser], carve.ScanDetail)
+	}
+	content, err := filecarves.ContentRange(carve, entry.Offset, entry.Size)
+	if err != nil {
+		apiErrorResponse(w, "error extracting file", http.StatusInternalServerError, err)
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	defer content.Close()
+	// Extracting single files is audited, it is the most sensitive access to carves
+	recordActivity(env, environments.ActivityCarves, ctx[ctxUser], carves.ExtractSummary(carve, entry), "", "")
+	w.Header().Set(utils.ContentType, "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(entry.Path)))
+	w.Header().Set("Content-Length", strconv.FormatInt(entry.Size, 10))
+	w.Header().Set("X-Osctrl-Sha256", entry.SHA256)
+	w.Header().Set("X-Osctrl-Scan-Verdict", carve.ScanVerdict)
+	w.WriteHeader(http.StatusOK)
+	counter := &environments.AccessCounter{ResponseWriter: w}
+	if _, err := io.Copy(counter, content); err != nil {
+		log.Printf("error downloading file of carve %s - %v", carve.CarveID, err)
+	}
+	recordAccess(r, env.ID, environments.AccessCarveFile, carve.QueryName, entry.Path, counter.Bytes)
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Extracted %s from carve %s", entry.Path, carve.CarveID)
+	}
+	incMetric(metricAPICarvesOK)
+}
+
+// POST Handler to build again the manifest of a completed carve, the status is pending until it is built
+func apiCarveIndexHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPICarvesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	_, carve, ok := carveSession(w, r)
+	if !ok {
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	if err := filecarves.StartManifest(carve.SessionID); err != nil {
+		apiErrorResponse(w, "error indexing carve", http.StatusBadRequest, err)
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Indexing carve %s", carve.CarveID)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusAccepted, types.ApiGenericResponse{Message: "indexing of carve " + carve.CarveID + " started"})
+	incMetric(metricAPICarvesOK)
+}
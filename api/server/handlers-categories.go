@@ -0,0 +1,161 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+const (
+	metricAPICategoriesReq = "categories-req"
+	metricAPICategoriesErr = "categories-err"
+	metricAPICategoriesOK  = "categories-ok"
+)
+
+// Helper to get the policy of the category of an environment, the default one if it fails to load
+func categoryPolicy(env environments.TLSEnvironment) environments.CategoryPolicy {
+	policy, err := envs.CategoryPolicy(env.Category)
+	if err != nil {
+		log.Printf("error getting category policy %v", err)
+	}
+	return policy
+}
+
+// Helper to check if a launch of a query or carve is confirmed as the policy of the category of the environment asks.
+// Launches by time window or attributes are counted as all the active nodes of the environment, because their
+// targets are only known once they are created.
+func checkLaunch(env environments.TLSEnvironment, uuid string, broad bool, typed string) error {
+	policy := categoryPolicy(env)
+	if policy.ConfirmTargets <= 0 {
+		return nil
+	}
+	targets := 0
+	if uuid != "" {
+		targets = 1
+	}
+	if broad {
+		active, err := nodesmgr.GetByEnv(env.Name, "active", settingsmgr.InactiveHours())
+		if err != nil {
+			return err
+		}
+		targets = len(active)
+	}
+	return policy.CheckLaunch(env, targets, typed)
+}
+
+// POST Handler to set the category of an environment, empty to remove it
+func apiSetCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPICategoriesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, actor, ok := envWithAccess(w, r, users.AdminLevel)
+	if !ok {
+		incMetric(metricAPICategoriesErr)
+		return
+	}
+	var c types.ApiCategoryRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPICategoriesErr)
+		return
+	}
+	if !environments.IsCategory(c.Category) {
+		apiErrorResponse(w, "invalid category", http.StatusBadRequest, fmt.Errorf("category %s", c.Category))
+		incMetric(metricAPICategoriesErr)
+		return
+	}
+	if err := envs.UpdateCategory(env.Name, c.Category); err != nil {
+		apiErrorResponse(w, "error setting category", http.StatusInternalServerError, err)
+		incMetric(metricAPICategoriesErr)
+		return
+	}
+	before := environments.ActivityValue(env, environments.ActivityCategory)
+	env.Category = c.Category
+	recordActivity(env, environments.ActivityCategory, actor, fmt.Sprintf("category set to %q", c.Category), before, environments.ActivityValue(env, environments.ActivityCategory))
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Category of %s updated", env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, env)
+	incMetric(metricAPICategoriesOK)
+}
+
+// GET Handler to return the policies of all the categories of environments
+func apiCategoryPoliciesHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPICategoriesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.AdminLevel, users.NoEnvironment) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPICategoriesErr)
+		return
+	}
+	policies, err := envs.CategoryPolicies()
+	if err != nil {
+		apiErrorResponse(w, "error getting category policies", http.StatusInternalServerError, err)
+		incMetric(metricAPICategoriesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Println("DebugService: Returned category policies")
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, policies)
+	incMetric(metricAPICategoriesOK)
+}
+
+// POST Handler to change the policy of a category of environments, recorded in the activity of its environments
+func apiSetCategoryPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPICategoriesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.AdminLevel, users.NoEnvironment) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPICategoriesErr)
+		return
+	}
+	var p environments.CategoryPolicy
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPICategoriesErr)
+		return
+	}
+	if err := envs.SetCategoryPolicy(p, ctx[ctxUser]); err != nil {
+		apiErrorResponse(w, "error setting category policy", http.StatusBadRequest, err)
+		incMetric(metricAPICategoriesErr)
+		return
+	}
+	envAll, err := envs.All()
+	if err != nil {
+		apiErrorResponse(w, "error getting environments", http.StatusInternalServerError, err)
+		incMetric(metricAPICategoriesErr)
+		return
+	}
+	for _, env := range envAll {
+		if env.Category == p.Category {
+			recordActivity(env, environments.ActivityCategory, ctx[ctxUser], environments.PolicySummary(p), "", "")
+		}
+	}
+	policy, err := envs.CategoryPolicy(p.Category)
+	if err != nil {
+		apiErrorResponse(w, "error getting category policy", http.StatusInternalServerError, err)
+		incMetric(metricAPICategoriesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Policy of category %s updated", p.Category)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, policy)
+	incMetric(metricAPICategoriesOK)
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+const (
+	metricAPICertsReq = "certs-req"
+	metricAPICertsErr = "certs-err"
+	metricAPICertsOK  = "certs-ok"
+)
+
+// GET Handler to return the propagation of the certificate of one environment as JSON
+func apiCertificateStatusHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPICertsReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPICertsErr)
+		return
+	}
+	// Get environment by name
+	env, err := envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		if err.Error() == "record not found" {
+			apiErrorResponse(w, "environment not found", http.StatusNotFound, err)
+		} else {
+			apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, err)
+		}
+		incMetric(metricAPICertsErr)
+		return
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.UserLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPICertsErr)
+		return
+	}
+	// Get nodes in the environment
+	stats, err := nodesmgr.GetStatsByEnv(env.Name, settingsmgr.InactiveHours())
+	if err != nil {
+		apiErrorResponse(w, "error getting nodes", http.StatusInternalServerError, err)
+		incMetric(metricAPICertsErr)
+		return
+	}
+	status, err := envs.GetCertificateStatus(env, stats.Total)
+	if err != nil {
+		apiErrorResponse(w, "error getting certificate status", http.StatusInternalServerError, err)
+		incMetric(metricAPICertsErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned certificate status for %s", env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, status)
+	incMetric(metricAPICertsOK)
+}
+
+// POST Handler to stage a new version of the certificate of one environment
+func apiCertificateHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPICertsReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPICertsErr)
+		return
+	}
+	// Get environment by name
+	env, err := envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		if err.Error() == "record not found" {
+			apiErrorResponse(w, "environment not found", http.StatusNotFound, err)
+		} else {
+			apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, err)
+		}
+		incMetric(metricAPICertsErr)
+		return
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.AdminLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPICertsErr)
+		return
+	}
+	var c types.ApiCertificateRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPICertsErr)
+		return
+	}
+	cert, err := envs.StageCertificate(env, c.Certificate, c.ActivateAt)
+	if err != nil {
+		apiErrorResponse(w, "error saving certificate", http.StatusBadRequest, err)
+		incMetric(metricAPICertsErr)
+		return
+	}
+	// Return message as serialized response
+	msg := fmt.Sprintf("certificate version %d activates at %s", cert.Version, cert.ActivateAt)
+	recordActivity(env, environments.ActivityCertificate, ctx[ctxUser], msg, env.Certificate, c.Certificate)
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: %s for %s", msg, env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiGenericResponse{Message: msg})
+	incMetric(metricAPICertsOK)
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+// Helper to prepare the snapshots of nodes of one environment, with the configuration served to each of them
+func nodeSnapshots(ctx context.Context, env environments.TLSEnvironment, nds []nodes.OsqueryNode) ([]nodes.NodeSnapshot, error) {
+	profiles, err := envs.ProfilesCtx(ctx, env.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting profiles - %v", err)
+	}
+	channels, err := envs.WindowsEventsCtx(ctx, env.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting windows event channels - %v", err)
+	}
+	denylist, err := envs.ScheduleDenylistCtx(ctx, env.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting denylist - %v", err)
+	}
+	overrides, err := envs.EnvScheduleOverrides(env.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting schedule overrides - %v", err)
+	}
+	nodeOverrides := make(map[string][]environments.ScheduleOverride)
+	for _, o := range overrides {
+		nodeOverrides[o.UUID] = append(nodeOverrides[o.UUID], o)
+	}
+	ids := make([]uint, 0, len(nds))
+	for _, n := range nds {
+		ids = append(ids, n.ID)
+	}
+	nodeTags, err := tagsmgr.TagNamesOfNodes(ids)
+	if err != nil {
+		return nil, fmt.Errorf("error getting tags - %v", err)
+	}
+	attrs, err := nodesmgr.AttributesOfNodes(nds)
+	if err != nil {
+		return nil, fmt.Errorf("error getting attributes - %v", err)
+	}
+	now := time.Now()
+	snapshots := make([]nodes.NodeSnapshot, 0, len(nds))
+	for _, n := range nds {
+		pNode := environments.ProfileNode{Platform: n.Platform, HardwareModel: nodes.HardwareModel(n), Tags: nodeTags[n.ID]}
+		conf, profile, err := environments.NodeConfiguration(env, profiles, channels, denylist, nodeOverrides[n.UUID], pNode)
+		if err != nil {
+			return nil, fmt.Errorf("error rendering configuration of %s - %v", n.UUID, err)
+		}
+		snapshot, err := nodes.NewNodeSnapshot(n, settingsmgr.InactiveHours(), profile, conf, nodeTags[n.ID], attrs[n.ID], now)
+		if err != nil {
+			return nil, fmt.Errorf("error preparing %s - %v", n.UUID, err)
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots, nil
+}
+
+// Helper to get the snapshot of a node to compare from environment/uuid, or the baseline of an environment
+// from environment/baseline. Returns the status code to use when it fails.
+func compareTarget(r *http.Request, ctx contextValue, value string) (nodes.NodeSnapshot, int, error) {
+	envVar, uuid, err := nodes.ParseCompareTarget(value)
+	if err != nil {
+		return nodes.NodeSnapshot{}, http.StatusBadRequest, err
+	}
+	env, err := envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		return nodes.NodeSnapshot{}, http.StatusNotFound, fmt.Errorf("error getting environment %s - %v", envVar, err)
+	}
+	if !checkAccess(ctx, users.UserLevel, env.UUID) {
+		return nodes.NodeSnapshot{}, http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser])
+	}
+	var nds []nodes.OsqueryNode
+	baseline := uuid == nodes.CompareBaseline
+	if baseline {
+		if nds, err = nodesmgr.GetByEnvCtx(r.Context(), env.Name, "active", settingsmgr.InactiveHours()); err != nil {
+			return nodes.NodeSnapshot{}, http.StatusInternalServerError, err
+		}
+		if len(nds) > nodes.BaselineSample {
+			nds = nds[:nodes.BaselineSample]
+		}
+	} else {
+		node, err := nodesmgr.GetByUUIDEnv(uuid, env.ID)
+		if err != nil {
+			return nodes.NodeSnapshot{}, http.StatusNotFound, fmt.Errorf("node %s not found - %v", value, err)
+		}
+		nds = []nodes.OsqueryNode{node}
+	}
+	snapshots, err := nodeSnapshots(r.Context(), env, nds)
+	if err != nil {
+		return nodes.NodeSnapshot{}, http.StatusInternalServerError, err
+	}
+	if baseline {
+		return nodes.BaselineSnapshot(env.Name, snapshots), http.StatusOK, nil
+	}
+	return snapshots[0], http.StatusOK, nil
+}
+
+// GET Handler to compare two nodes, or a node with the baseline of an environment, with ?a=env/uuid&b=env/uuid.
+// The differences are paginated in each section with ?page= and ?per_page=, and limited to sections with ?section=
+func apiCompareNodesHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPINodesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	query := r.URL.Query()
+	sections := query["section"]
+	for _, s := range sections {
+		if !nodes.ValidCompareSection(s) {
+			apiErrorResponse(w, fmt.Sprintf("invalid section %s", s), http.StatusBadRequest, nil)
+			incMetric(metricAPINodesErr)
+			return
+		}
+	}
+	page, perPage := 1, nodes.DefaultComparePerPage
+	if v := query.Get("page"); v != "" {
+		var err error
+		if page, err = strconv.Atoi(v); err != nil || page < 1 {
+			apiErrorResponse(w, "invalid page", http.StatusBadRequest, fmt.Errorf("page %s", v))
+			incMetric(metricAPINodesErr)
+			return
+		}
+	}
+	if v := query.Get("per_page"); v != "" {
+		var err error
+		if perPage, err = strconv.Atoi(v); err != nil || perPage < 1 {
+			apiErrorResponse(w, "invalid per_page", http.StatusBadRequest, fmt.Errorf("per_page %s", v))
+			incMetric(metricAPINodesErr)
+			return
+		}
+	}
+	a, status, err := compareTarget(r, ctx, query.Get("a"))
+	if err != nil {
+		apiErrorResponse(w, "error with node a", status, err)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	b, status, err := compareTarget(r, ctx, query.Get("b"))
+	if err != nil {
+		apiErrorResponse(w, "error with node b", status, err)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	comparison := nodes.CompareSnapshots(a, b, sections, page, perPage)
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned comparison of %s and %s", a.Name(), b.Name())
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, comparison)
+	incMetric(metricAPINodesOK)
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/jmpsec/osctrl/queries"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+const (
+	metricAPICoverageReq = "coverage-req"
+	metricAPICoverageErr = "coverage-err"
+	metricAPICoverageOK  = "coverage-ok"
+)
+
+// Helper to get the default expiration of the queries of an environment and the range allowed to override it
+func queryExpiration(env environments.TLSEnvironment) queries.ExpirationRange {
+	return queries.NewExpirationRange(int64(categoryPolicy(env).Expiration(env)), settingsmgr.QueryExpirationMinHours(), settingsmgr.QueryExpirationMaxHours())
+}
+
+// GET Handler to estimate how many nodes are expected to check in within a window, like ?window=24h&targets=uuid1,host2&platforms=darwin
+func apiCoverageEstimateHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPICoverageReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, _, ok := envWithAccess(w, r, users.UserLevel)
+	if !ok {
+		incMetric(metricAPICoverageErr)
+		return
+	}
+	q := r.URL.Query()
+	hours, err := queries.ParseExpiration(q.Get("window"))
+	if err != nil {
+		apiErrorResponse(w, "invalid window", http.StatusBadRequest, err)
+		incMetric(metricAPICoverageErr)
+		return
+	}
+	if hours == 0 {
+		hours = queryExpiration(env).Default
+	}
+	all, err := nodesmgr.GetByEnvCtx(r.Context(), env.Name, "all", 0)
+	if err != nil {
+		apiErrorResponse(w, "error getting nodes", http.StatusInternalServerError, err)
+		incMetric(metricAPICoverageErr)
+		return
+	}
+	targets := nodes.CoverageTargets(all, strings.Split(q.Get("targets"), ","), strings.Split(q.Get("platforms"), ","))
+	estimate := nodes.EstimateCoverage(targets, time.Now(), time.Duration(hours)*time.Hour)
+	estimate.Environment = env.Name
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned coverage estimate for %s", env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, estimate)
+	incMetric(metricAPICoverageOK)
+}
+
+// GET Handler to return the default expiration of the queries of an environment and the range allowed to override it
+func apiQueryExpirationHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPICoverageReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, _, ok := envWithAccess(w, r, users.UserLevel)
+	if !ok {
+		incMetric(metricAPICoverageErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned query expiration for %s", env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, queryExpiration(env))
+	incMetric(metricAPICoverageOK)
+}
+
+// POST Handler to set the default expiration in hours of the queries of an environment, 0 for the global default
+func apiSetQueryExpirationHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPICoverageReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, actor, ok := envWithAccess(w, r, users.AdminLevel)
+	if !ok {
+		incMetric(metricAPICoverageErr)
+		return
+	}
+	var q types.ApiQueryExpirationRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPICoverageErr)
+		return
+	}
+	if q.Hours != 0 {
+		limits := queries.NewExpirationRange(0, settingsmgr.QueryExpirationMinHours(), settingsmgr.QueryExpirationMaxHours())
+		if _, err := limits.Hours(int64(q.Hours)); err != nil {
+			apiErrorResponse(w, "invalid query expiration", http.StatusBadRequest, err)
+			incMetric(metricAPICoverageErr)
+			return
+		}
+	}
+	if err := envs.UpdateQueryExpiration(env.Name, q.Hours); err != nil {
+		apiErrorResponse(w, "error setting query expiration", http.StatusInternalServerError, err)
+		incMetric(metricAPICoverageErr)
+		return
+	}
+	before := queryExpiration(env)
+	env.QueryExpiration = q.Hours
+	after := queryExpiration(env)
+	recordActivity(env, environments.ActivityQueries, actor, fmt.Sprintf("default query expiration set to %dh", q.Hours),
+		fmt.Sprintf("default: %dh", before.Default), fmt.Sprintf("default: %dh", after.Default))
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Query expiration of %s updated", env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, after)
+	incMetric(metricAPICoverageOK)
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/logging"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+const (
+	metricAPIDeadLettersReq = "dead-letters-req"
+	metricAPIDeadLettersErr = "dead-letters-err"
+	metricAPIDeadLettersOK  = "dead-letters-ok"
+)
+
+// Default number of dead letters returned
+const defaultDeadLettersLimit = 100
+
+// Helper to check that the user of the request is an administrator, dead letters include logs of all environments
+func deadLettersAccess(w http.ResponseWriter, r *http.Request) (string, bool) {
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.AdminLevel, users.NoEnvironment) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		return ctx[ctxUser], false
+	}
+	return ctx[ctxUser], true
+}
+
+// Helper to prepare the dead letters filter of a replay or purge request, requests without filter must ask for all
+func deadLettersFilter(req types.ApiDeadLetterRequest) (logging.DeadLetterFilter, error) {
+	filter := logging.DeadLetterFilter{
+		IDs:         req.IDs,
+		Backend:     req.Backend,
+		Reason:      req.Reason,
+		Environment: req.Environment,
+		Limit:       req.Limit,
+	}
+	if req.OlderThan != "" {
+		before, err := environments.ParseSince(req.OlderThan, time.Now())
+		if err != nil {
+			return filter, err
+		}
+		filter.Before = before
+	}
+	if filter.Empty() && !req.All {
+		return filter, fmt.Errorf("no dead letters selected, use all to select every dead letter")
+	}
+	return filter, nil
+}
+
+// GET Handler to return the counts by reason and the latest dead letters, filtered by ?backend=&reason=&env=&limit=
+func apiDeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIDeadLettersReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	if _, ok := deadLettersAccess(w, r); !ok {
+		incMetric(metricAPIDeadLettersErr)
+		return
+	}
+	query := r.URL.Query()
+	filter := logging.DeadLetterFilter{
+		Backend:     query.Get("backend"),
+		Reason:      query.Get("reason"),
+		Environment: query.Get("env"),
+		Limit:       defaultDeadLettersLimit,
+	}
+	if l := query.Get("limit"); l != "" {
+		limit, err := strconv.Atoi(l)
+		if err != nil || limit <= 0 {
+			apiErrorResponse(w, "invalid limit", http.StatusBadRequest, err)
+			incMetric(metricAPIDeadLettersErr)
+			return
+		}
+		filter.Limit = limit
+	}
+	report, err := deadletters.Report(filter)
+	if err != nil {
+		apiErrorResponse(w, "error getting dead letters", http.StatusInternalServerError, err)
+		incMetric(metricAPIDeadLettersErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Println("DebugService: Returned dead letters")
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, report)
+	incMetric(metricAPIDeadLettersOK)
+}
+
+// POST Handler to replay dead letters, to their backend or to a different one
+func apiDeadLettersReplayHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIDeadLettersReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	user, ok := deadLettersAccess(w, r)
+	if !ok {
+		incMetric(metricAPIDeadLettersErr)
+		return
+	}
+	var req types.ApiDeadLetterRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPIDeadLettersErr)
+		return
+	}
+	filter, err := deadLettersFilter(req)
+	if err != nil {
+		apiErrorResponse(w, "invalid dead letters", http.StatusBadRequest, err)
+		incMetric(metricAPIDeadLettersErr)
+		return
+	}
+	replayed, err := deadletters.Replay(filter, req.Target)
+	if err != nil {
+		apiErrorResponse(w, "error replaying dead letters", http.StatusInternalServerError, err)
+		incMetric(metricAPIDeadLettersErr)
+		return
+	}
+	// Return message as serialized response
+	msg := fmt.Sprintf("%d dead letters queued for replay", replayed)
+	log.Printf("%s by %s", msg, user)
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiGenericResponse{Message: msg})
+	incMetric(metricAPIDeadLettersOK)
+}
+
+// POST Handler to purge dead letters
+func apiDeadLettersPurgeHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIDeadLettersReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	user, ok := deadLettersAccess(w, r)
+	if !ok {
+		incMetric(metricAPIDeadLettersErr)
+		return
+	}
+	var req types.ApiDeadLetterRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPIDeadLettersErr)
+		return
+	}
+	filter, err := deadLettersFilter(req)
+	if err != nil {
+		apiErrorResponse(w, "invalid dead letters", http.StatusBadRequest, err)
+		incMetric(metricAPIDeadLettersErr)
+		return
+	}
+	purged, err := deadletters.Purge(filter)
+	if err != nil {
+		apiErrorResponse(w, "error purging dead letters", http.StatusInternalServerError, err)
+		incMetric(metricAPIDeadLettersErr)
+		return
+	}
+	// Return message as serialized response
+	msg := fmt.Sprintf("%d dead letters purged", purged)
+	log.Printf("%s by %s", msg, user)
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiGenericResponse{Message: msg})
+	incMetric(metricAPIDeadLettersOK)
+}
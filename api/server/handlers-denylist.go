@@ -0,0 +1,151 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	metricAPIDenylistReq = "denylist-req"
+	metricAPIDenylistErr = "denylist-err"
+	metricAPIDenylistOK  = "denylist-ok"
+)
+
+// GET Handler to return the active scheduled query denylist of one environment as JSON
+func apiDenylistHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIDenylistReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, _, ok := envWithAccess(w, r, users.UserLevel)
+	if !ok {
+		incMetric(metricAPIDenylistErr)
+		return
+	}
+	denylist, err := envs.ScheduleDenylistCtx(r.Context(), env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting denylist", http.StatusInternalServerError, err)
+		incMetric(metricAPIDenylistErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned denylist for %s", env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, denylist)
+	incMetric(metricAPIDenylistOK)
+}
+
+// GET Handler to return the queries and packs in the configuration of one environment that can be denied
+func apiDenylistCandidatesHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIDenylistReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, _, ok := envWithAccess(w, r, users.UserLevel)
+	if !ok {
+		incMetric(metricAPIDenylistErr)
+		return
+	}
+	candidates, err := environments.DenylistCandidates([]byte(env.Configuration))
+	if err != nil {
+		apiErrorResponse(w, "error parsing configuration", http.StatusInternalServerError, err)
+		incMetric(metricAPIDenylistErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned denylist candidates for %s", env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, candidates)
+	incMetric(metricAPIDenylistOK)
+}
+
+// POST Handler to add an entry to the scheduled query denylist of one environment
+func apiAddDenyHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIDenylistReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, actor, ok := envWithAccess(w, r, users.AdminLevel)
+	if !ok {
+		incMetric(metricAPIDenylistErr)
+		return
+	}
+	var d types.ApiDenylistRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPIDenylistErr)
+		return
+	}
+	entry := environments.ScheduleDeny{
+		Pack:     d.Pack,
+		Query:    d.Query,
+		Platform: d.Platform,
+		Tag:      d.Tag,
+		Reason:   d.Reason,
+		Creator:  actor,
+	}
+	if d.Hours > 0 {
+		entry.ExpiresAt = time.Now().Add(time.Duration(d.Hours) * time.Hour)
+	}
+	saved, err := envs.AddScheduleDeny(env.ID, entry)
+	if err != nil {
+		apiErrorResponse(w, "error adding to denylist", http.StatusBadRequest, err)
+		incMetric(metricAPIDenylistErr)
+		return
+	}
+	recordActivity(env, environments.ActivityDenylist, actor, "added to denylist "+saved.Summary(), "", "")
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Added %s to denylist for %s", saved.Target(), env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, saved)
+	incMetric(metricAPIDenylistOK)
+}
+
+// POST Handler to lift an entry of the scheduled query denylist of one environment before it expires
+func apiLiftDenyHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIDenylistReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, actor, ok := envWithAccess(w, r, users.AdminLevel)
+	if !ok {
+		incMetric(metricAPIDenylistErr)
+		return
+	}
+	var d types.ApiDenylistRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPIDenylistErr)
+		return
+	}
+	entry, err := envs.GetScheduleDeny(env.ID, d.ID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			apiErrorResponse(w, "denylist entry not found", http.StatusNotFound, err)
+		} else {
+			apiErrorResponse(w, "error getting denylist entry", http.StatusInternalServerError, err)
+		}
+		incMetric(metricAPIDenylistErr)
+		return
+	}
+	if err := envs.DeleteScheduleDeny(env.ID, entry.ID); err != nil {
+		apiErrorResponse(w, "error lifting denylist entry", http.StatusInternalServerError, err)
+		incMetric(metricAPIDenylistErr)
+		return
+	}
+	// Return message as serialized response
+	msg := fmt.Sprintf("lifted from denylist %s", entry.Target())
+	recordActivity(env, environments.ActivityDenylist, actor, msg, "", "")
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: %s for %s", msg, env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiGenericResponse{Message: msg})
+	incMetric(metricAPIDenylistOK)
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	metricAPIEnrollFieldsReq = "enroll-fields-req"
+	metricAPIEnrollFieldsErr = "enroll-fields-err"
+	metricAPIEnrollFieldsOK  = "enroll-fields-ok"
+)
+
+// Helper to get the environment of a request and check access with the given level
+func envWithAccess(w http.ResponseWriter, r *http.Request, level users.AccessLevel) (environments.TLSEnvironment, string, bool) {
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
+		return environments.TLSEnvironment{}, "", false
+	}
+	// Get environment by name
+	env, err := envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		if err.Error() == "record not found" {
+			apiErrorResponse(w, "environment not found", http.StatusNotFound, err)
+		} else {
+			apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, err)
+		}
+		return env, "", false
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, level, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		return env, "", false
+	}
+	return env, ctx[ctxUser], true
+}
+
+// GET Handler to return the enroll metadata fields of one environment as JSON
+func apiEnrollFieldsHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIEnrollFieldsReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, _, ok := envWithAccess(w, r, users.UserLevel)
+	if !ok {
+		incMetric(metricAPIEnrollFieldsErr)
+		return
+	}
+	fields, err := envs.EnrollFieldsCtx(r.Context(), env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting enroll fields", http.StatusInternalServerError, err)
+		incMetric(metricAPIEnrollFieldsErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned enroll fields for %s", env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, fields)
+	incMetric(metricAPIEnrollFieldsOK)
+}
+
+// POST Handler to create or update an enroll metadata field of one environment
+func apiSetEnrollFieldHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIEnrollFieldsReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, actor, ok := envWithAccess(w, r, users.AdminLevel)
+	if !ok {
+		incMetric(metricAPIEnrollFieldsErr)
+		return
+	}
+	var f types.ApiEnrollFieldRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&f); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPIEnrollFieldsErr)
+		return
+	}
+	field, err := envs.SetEnrollField(env.ID, environments.EnrollField{
+		Name:        f.Name,
+		Description: f.Description,
+		Regex:       f.Regex,
+		Required:    f.Required,
+	})
+	if err != nil {
+		apiErrorResponse(w, "error saving enroll field", http.StatusBadRequest, err)
+		incMetric(metricAPIEnrollFieldsErr)
+		return
+	}
+	recordActivity(env, environments.ActivityEnrollFields, actor, "enroll field "+field.Name+" saved", "", "")
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Saved enroll field %s for %s", field.Name, env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, field)
+	incMetric(metricAPIEnrollFieldsOK)
+}
+
+// POST Handler to delete an enroll metadata field of one environment
+func apiDeleteEnrollFieldHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIEnrollFieldsReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, actor, ok := envWithAccess(w, r, users.AdminLevel)
+	if !ok {
+		incMetric(metricAPIEnrollFieldsErr)
+		return
+	}
+	var f types.ApiEnrollFieldRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&f); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPIEnrollFieldsErr)
+		return
+	}
+	if err := envs.DeleteEnrollField(env.ID, f.Name); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			apiErrorResponse(w, "enroll field not found", http.StatusNotFound, err)
+		} else {
+			apiErrorResponse(w, "error deleting enroll field", http.StatusInternalServerError, err)
+		}
+		incMetric(metricAPIEnrollFieldsErr)
+		return
+	}
+	// Return message as serialized response
+	msg := fmt.Sprintf("enroll field %s deleted", f.Name)
+	recordActivity(env, environments.ActivityEnrollFields, actor, msg, "", "")
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: %s for %s", msg, env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiGenericResponse{Message: msg})
+	incMetric(metricAPIEnrollFieldsOK)
+}
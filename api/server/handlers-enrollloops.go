@@ -0,0 +1,42 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+const (
+	metricAPIEnrollLoopsReq = "enroll-loops-req"
+	metricAPIEnrollLoopsErr = "enroll-loops-err"
+	metricAPIEnrollLoopsOK  = "enroll-loops-ok"
+)
+
+// GET Handler to return the hosts of an environment that enroll in a loop, with hints at the cause
+func apiEnrollLoopsHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIEnrollLoopsReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, _, ok := envWithAccess(w, r, users.UserLevel)
+	if !ok {
+		incMetric(metricAPIEnrollLoopsErr)
+		return
+	}
+	cfg := nodes.NewEnrollLoopConfig(env.EnrollLoopLimit, env.EnrollLoopQuiet, env.EnrollBackoff)
+	report, err := nodesmgr.EnrollLoopReport(env.ID, cfg, time.Now())
+	if err != nil {
+		apiErrorResponse(w, "error getting enroll loops", http.StatusInternalServerError, err)
+		incMetric(metricAPIEnrollLoopsErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned %d enroll loops for %s", len(report.Loops), env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, report)
+	incMetric(metricAPIEnrollLoopsOK)
+}
@@ -1,4 +1,4 @@
-package main
+package server
 
 import (
 	"fmt"
@@ -6,6 +6,7 @@ import (
 	"net/http"
 
 	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/environments"
 	"github.com/jmpsec/osctrl/settings"
 	"github.com/jmpsec/osctrl/users"
 	"github.com/jmpsec/osctrl/utils"
@@ -30,7 +31,7 @@ func apiEnvironmentHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// Get environment by name
-	env, err := envs.Get(envVar)
+	env, err := envs.GetCtx(r.Context(), envVar)
 	if err != nil {
 		if err.Error() == "record not found" {
 			apiErrorResponse(w, "environment not found", http.StatusNotFound, err)
@@ -42,11 +43,16 @@ func apiEnvironmentHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	// Get context data and check access
 	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
-	if !apiUsers.CheckPermissions(ctx[ctxUser], users.UserLevel, env.UUID) {
+	if !checkAccess(ctx, users.UserLevel, env.UUID) {
 		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
 		incMetric(metricAPIEnvsErr)
 		return
 	}
+	// Clients with the current configuration version get 304
+	if notModified(w, r, fmt.Sprintf(`"env-%d-%d-%d"`, env.ID, env.ConfigVersion, env.UpdatedAt.UnixNano())) {
+		incMetric(metricAPIEnvsOK)
+		return
+	}
 	// Serialize and serve JSON
 	if settingsmgr.DebugService(settings.ServiceAPI) {
 		log.Printf("DebugService: Returned environment %s", env.Name)
@@ -61,7 +67,7 @@ func apiEnvironmentsHandler(w http.ResponseWriter, r *http.Request) {
 	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
 	// Get context data and check access
 	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
-	if !apiUsers.CheckPermissions(ctx[ctxUser], users.AdminLevel, users.NoEnvironment) {
+	if !checkAccess(ctx, users.AdminLevel, users.NoEnvironment) {
 		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
 		incMetric(metricAPIEnvsErr)
 		return
@@ -73,6 +79,16 @@ func apiEnvironmentsHandler(w http.ResponseWriter, r *http.Request) {
 		incMetric(metricAPIEnvsErr)
 		return
 	}
+	// Filter by category
+	if category := r.URL.Query().Get("category"); category != "" {
+		filtered := []environments.TLSEnvironment{}
+		for _, e := range envAll {
+			if e.Category == category {
+				filtered = append(filtered, e)
+			}
+		}
+		envAll = filtered
+	}
 	// Serialize and serve JSON
 	if settingsmgr.DebugService(settings.ServiceAPI) {
 		log.Println("DebugService: Returned environments")
@@ -0,0 +1,281 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/tags"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+const (
+	metricAPIEnvTemplatesReq = "envtemplates-req"
+	metricAPIEnvTemplatesErr = "envtemplates-err"
+	metricAPIEnvTemplatesOK  = "envtemplates-ok"
+)
+
+// APIEnvTemplateRequest to receive a new template or a new version of a template. New templates
+// start from the configuration of Environment when it is set.
+type APIEnvTemplateRequest struct {
+	Name        string                      `json:"name"`
+	Description string                      `json:"description"`
+	Environment string                      `json:"environment"`
+	Note        string                      `json:"note"`
+	Config      environments.TemplateConfig `json:"config"`
+}
+
+// Helper to check that the user of a request can manage templates, which is not limited to an environment
+func templatesAccess(w http.ResponseWriter, r *http.Request) (string, bool) {
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.AdminLevel, users.NoEnvironment) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		return "", false
+	}
+	return ctx[ctxUser], true
+}
+
+// GET Handler to return all environment templates as JSON
+func apiEnvTemplatesHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIEnvTemplatesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	if _, ok := templatesAccess(w, r); !ok {
+		incMetric(metricAPIEnvTemplatesErr)
+		return
+	}
+	envTemplates, err := envs.Templates()
+	if err != nil {
+		apiErrorResponse(w, "error getting templates", http.StatusInternalServerError, err)
+		incMetric(metricAPIEnvTemplatesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Println("DebugService: Returned environment templates")
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, envTemplates)
+	incMetric(metricAPIEnvTemplatesOK)
+}
+
+// GET Handler to return one environment template as JSON
+func apiEnvTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIEnvTemplatesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	if _, ok := templatesAccess(w, r); !ok {
+		incMetric(metricAPIEnvTemplatesErr)
+		return
+	}
+	envTemplate, err := envs.GetTemplate(mux.Vars(r)["name"])
+	if err != nil {
+		apiErrorResponse(w, "template not found", http.StatusNotFound, err)
+		incMetric(metricAPIEnvTemplatesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned environment template %s", envTemplate.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, envTemplate)
+	incMetric(metricAPIEnvTemplatesOK)
+}
+
+// POST Handler to create an environment template
+func apiNewEnvTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIEnvTemplatesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	actor, ok := templatesAccess(w, r)
+	if !ok {
+		incMetric(metricAPIEnvTemplatesErr)
+		return
+	}
+	var t APIEnvTemplateRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusBadRequest, err)
+		incMetric(metricAPIEnvTemplatesErr)
+		return
+	}
+	newTemplate := environments.EnvTemplate{Name: t.Name, Description: t.Description, TemplateConfig: t.Config}
+	if t.Environment != "" {
+		env, err := envs.Get(t.Environment)
+		if err != nil {
+			apiErrorResponse(w, "error getting environment", http.StatusBadRequest, err)
+			incMetric(metricAPIEnvTemplatesErr)
+			return
+		}
+		newTemplate.TemplateConfig = envs.TemplateOf(env)
+	}
+	created, err := envs.CreateTemplate(newTemplate, actor)
+	if err != nil {
+		apiErrorResponse(w, "error creating template", http.StatusBadRequest, err)
+		incMetric(metricAPIEnvTemplatesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Environment template %s created", created.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, created)
+	incMetric(metricAPIEnvTemplatesOK)
+}
+
+// POST Handler to save a new version of an environment template
+func apiUpdateEnvTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIEnvTemplatesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	actor, ok := templatesAccess(w, r)
+	if !ok {
+		incMetric(metricAPIEnvTemplatesErr)
+		return
+	}
+	var t APIEnvTemplateRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusBadRequest, err)
+		incMetric(metricAPIEnvTemplatesErr)
+		return
+	}
+	updated, err := envs.UpdateTemplate(mux.Vars(r)["name"], t.Description, t.Config, actor, t.Note)
+	if err != nil {
+		apiErrorResponse(w, "error updating template", http.StatusBadRequest, err)
+		incMetric(metricAPIEnvTemplatesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Environment template %s is at version %d", updated.Name, updated.Version)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, updated)
+	incMetric(metricAPIEnvTemplatesOK)
+}
+
+// POST Handler to delete an environment template, environments created from it are not changed
+func apiDeleteEnvTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIEnvTemplatesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	if _, ok := templatesAccess(w, r); !ok {
+		incMetric(metricAPIEnvTemplatesErr)
+		return
+	}
+	name := mux.Vars(r)["name"]
+	if err := envs.DeleteTemplate(name); err != nil {
+		apiErrorResponse(w, "error deleting template", http.StatusBadRequest, err)
+		incMetric(metricAPIEnvTemplatesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Environment template %s deleted", name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiGenericResponse{Message: "template deleted"})
+	incMetric(metricAPIEnvTemplatesOK)
+}
+
+// GET Handler to return what would change in each environment created from a template with its latest version
+func apiPropagateDiffsHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIEnvTemplatesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	if _, ok := templatesAccess(w, r); !ok {
+		incMetric(metricAPIEnvTemplatesErr)
+		return
+	}
+	_, diffs, err := envs.PropagateDiffs(mux.Vars(r)["name"])
+	if err != nil {
+		apiErrorResponse(w, "error getting differences", http.StatusBadRequest, err)
+		incMetric(metricAPIEnvTemplatesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Println("DebugService: Returned template propagation differences")
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, diffs)
+	incMetric(metricAPIEnvTemplatesOK)
+}
+
+// POST Handler to update environments created from a template with its latest version
+func apiPropagateHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIEnvTemplatesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	actor, ok := templatesAccess(w, r)
+	if !ok {
+		incMetric(metricAPIEnvTemplatesErr)
+		return
+	}
+	var p types.ApiPropagateRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusBadRequest, err)
+		incMetric(metricAPIEnvTemplatesErr)
+		return
+	}
+	if len(p.Environments) == 0 {
+		apiErrorResponse(w, "environments are required", http.StatusBadRequest, nil)
+		incMetric(metricAPIEnvTemplatesErr)
+		return
+	}
+	updated, err := envs.PropagateTemplate(mux.Vars(r)["name"], p.Environments, actor)
+	if err != nil {
+		apiErrorResponse(w, "error propagating template", http.StatusBadRequest, err)
+		incMetric(metricAPIEnvTemplatesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Template propagated to %d environments", len(updated))
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, updated)
+	incMetric(metricAPIEnvTemplatesOK)
+}
+
+// POST Handler to create an environment from a template, with new secrets and paths
+func apiNewEnvironmentHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIEnvTemplatesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	actor, ok := templatesAccess(w, r)
+	if !ok {
+		incMetric(metricAPIEnvTemplatesErr)
+		return
+	}
+	var e types.ApiEnvironmentRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusBadRequest, err)
+		incMetric(metricAPIEnvTemplatesErr)
+		return
+	}
+	if e.Name == "" || e.Template == "" {
+		apiErrorResponse(w, "name and template are required", http.StatusBadRequest, nil)
+		incMetric(metricAPIEnvTemplatesErr)
+		return
+	}
+	if envs.Exists(e.Name) {
+		apiErrorResponse(w, "environment already exists", http.StatusConflict, nil)
+		incMetric(metricAPIEnvTemplatesErr)
+		return
+	}
+	env, err := envs.CreateFromTemplate(e.Template, e.Name, e.Hostname, actor)
+	if err != nil {
+		apiErrorResponse(w, "error creating environment", http.StatusBadRequest, err)
+		incMetric(metricAPIEnvTemplatesErr)
+		return
+	}
+	// Create a tag for this new environment
+	if err := tagsmgr.NewTag(env.Name, "Tag for environment "+env.Name, tags.RandomColor(), env.Icon, actor); err != nil {
+		apiErrorResponse(w, "error creating tag", http.StatusInternalServerError, err)
+		incMetric(metricAPIEnvTemplatesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Environment %s created from template %s", env.Name, e.Template)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, env)
+	incMetric(metricAPIEnvTemplatesOK)
+}
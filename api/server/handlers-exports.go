@@ -0,0 +1,252 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/queries"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+const (
+	metricAPIExportsReq = "exports-req"
+	metricAPIExportsErr = "exports-err"
+	metricAPIExportsOK  = "exports-ok"
+)
+
+const (
+	// Header with the SHA-256 of export artifacts, to verify downloads
+	exportHashHeader = "X-Export-SHA256"
+	// Interval to remove the artifacts of expired and canceled export jobs
+	exportCleanupInterval = 10 * time.Minute
+)
+
+// Helper to check if the user of a request can see an export job, only its creator and admins can
+func exportOwner(ctx contextValue, env environments.TLSEnvironment, job queries.ExportJob) bool {
+	return job.Creator == ctx[ctxUser] || checkAccess(ctx, users.AdminLevel, env.UUID)
+}
+
+// Helper to get an export job of the environment in the request by ID, only for its creator and admins
+func exportFromRequest(w http.ResponseWriter, r *http.Request, env environments.TLSEnvironment, ctx contextValue) (queries.ExportJob, bool) {
+	job, err := queriesmgr.GetExportJob(mux.Vars(r)["job"], env.ID)
+	if err != nil || !exportOwner(ctx, env, job) {
+		apiErrorResponse(w, "export job not found", http.StatusNotFound, err)
+		return job, false
+	}
+	return job, true
+}
+
+// Helper to materialize an export job with the results in the DB, pseudonymized as configured for the environment
+func runExportJob(env environments.TLSEnvironment, job queries.ExportJob, schema queries.ResultSchema) {
+	rows := func(fn func(node string, collected time.Time, row map[string]string) error) error {
+		return envQueryRows(env)(job.Query, fn)
+	}
+	job, err := queriesmgr.RunExportJob(context.Background(), job, schema, rows, exportStore, exportsTTL)
+	if err != nil {
+		if !errors.Is(err, queries.ErrExportCanceled) {
+			log.Printf("error materializing export job %s %v", job.JobID, err)
+		}
+		return
+	}
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Export job %s ready with %d rows and %d bytes", job.JobID, job.Rows, job.Size)
+	}
+}
+
+// Helper to remove the artifacts of expired and canceled export jobs periodically, until ctx is cancelled
+func cleanupExports(ctx context.Context) {
+	for {
+		removed, err := queriesmgr.CleanupExportJobs(ctx, exportStore, time.Now())
+		if err != nil {
+			log.Printf("error cleaning up export jobs %v", err)
+		}
+		if removed > 0 && settingsmgr.DebugService(settings.ServiceAPI) {
+			log.Printf("DebugService: Removed %d export artifacts", removed)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(exportCleanupInterval):
+		}
+	}
+}
+
+// Helper to respond to an export of results with the export job that materializes them, a new job is created if the user
+// does not have one for the same query and format
+func exportJobResponse(w http.ResponseWriter, env environments.TLSEnvironment, name, format, creator string, schema func() (queries.ResultSchema, error)) {
+	job, found, err := queriesmgr.FindExportJob(name, format, creator, env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting export jobs", http.StatusInternalServerError, err)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	if !found {
+		s, err := schema()
+		if err != nil {
+			apiErrorResponse(w, "error getting results", http.StatusInternalServerError, err)
+			incMetric(metricAPIQueriesErr)
+			return
+		}
+		job, err = queriesmgr.NewExportJob(queries.ExportJob{
+			EnvironmentID: env.ID,
+			Environment:   env.Name,
+			Query:         name,
+			Format:        format,
+			Rows:          s.Rows,
+			Creator:       creator,
+		}, exportsPerUser, exportsTTL)
+		if err != nil {
+			if errors.Is(err, queries.ErrExportLimit) {
+				apiErrorResponse(w, err.Error(), http.StatusTooManyRequests, nil)
+			} else {
+				apiErrorResponse(w, "error creating export job", http.StatusInternalServerError, err)
+			}
+			incMetric(metricAPIQueriesErr)
+			return
+		}
+		go runExportJob(env, job, s)
+	}
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Export job %s of %s is %s", job.JobID, name, job.Status)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusAccepted, job)
+	incMetric(metricAPIQueriesOK)
+}
+
+// GET Handler to return the export jobs of an environment, users only get their own
+func apiExportsHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIExportsReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, ctx, ok := sharesEnv(w, r)
+	if !ok {
+		incMetric(metricAPIExportsErr)
+		return
+	}
+	jobs, err := queriesmgr.ExportJobs(env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting export jobs", http.StatusInternalServerError, err)
+		incMetric(metricAPIExportsErr)
+		return
+	}
+	visible := []queries.ExportJob{}
+	for _, j := range jobs {
+		if exportOwner(ctx, env, j) {
+			visible = append(visible, j)
+		}
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned %d export jobs for %s", len(visible), env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, visible)
+	incMetric(metricAPIExportsOK)
+}
+
+// GET Handler to return the status of an export job
+func apiExportHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIExportsReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, ctx, ok := sharesEnv(w, r)
+	if !ok {
+		incMetric(metricAPIExportsErr)
+		return
+	}
+	job, ok := exportFromRequest(w, r, env, ctx)
+	if !ok {
+		incMetric(metricAPIExportsErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned export job %s", job.JobID)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, job)
+	incMetric(metricAPIExportsOK)
+}
+
+// GET Handler to download the artifact of an export job once it is ready, with support for range requests
+func apiExportDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIExportsReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, ctx, ok := sharesEnv(w, r)
+	if !ok {
+		incMetric(metricAPIExportsErr)
+		return
+	}
+	job, ok := exportFromRequest(w, r, env, ctx)
+	if !ok {
+		incMetric(metricAPIExportsErr)
+		return
+	}
+	// Results of queries restricted after the export are not downloaded either
+	if !apiCanViewName(r.Context(), ctx, job.Query, env.ID) {
+		apiErrorResponse(w, "query results are restricted", http.StatusForbidden, fmt.Errorf("attempt to download query %s by user %s", job.Query, ctx[ctxUser]))
+		incMetric(metricAPIExportsErr)
+		return
+	}
+	if !job.Downloadable(time.Now()) {
+		status := http.StatusConflict
+		if job.Status == queries.ExportJobExpired || job.Status == queries.ExportJobReady {
+			status = http.StatusGone
+		}
+		apiErrorResponse(w, "export job is not ready", status, fmt.Errorf("%s is %s", job.JobID, job.Status))
+		incMetric(metricAPIExportsErr)
+		return
+	}
+	if job.Storage != exportStore.Name() {
+		apiErrorResponse(w, "export artifact is not available", http.StatusGone, fmt.Errorf("%s is stored in %s", job.JobID, job.Storage))
+		incMetric(metricAPIExportsErr)
+		return
+	}
+	artifact, err := exportStore.Open(r.Context(), job.Location, job.Size)
+	if err != nil {
+		apiErrorResponse(w, "export artifact is not available", http.StatusGone, err)
+		incMetric(metricAPIExportsErr)
+		return
+	}
+	defer artifact.Close()
+	w.Header().Set(utils.ContentType, queries.ExportContentType(job.Format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", job.Filename()))
+	w.Header().Set("ETag", fmt.Sprintf("%q", job.SHA256))
+	w.Header().Set(exportHashHeader, job.SHA256)
+	// Downloads resumed in ranges are recorded once, with the size of the whole artifact
+	recordAccess(r, env.ID, environments.AccessQueryResult, job.Query, "export job "+job.JobID, job.Size)
+	http.ServeContent(w, r, job.Filename(), job.UpdatedAt, artifact)
+	incMetric(metricAPIExportsOK)
+}
+
+// POST Handler to cancel an export job, the artifact is removed if it was ready
+func apiExportCancelHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIExportsReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, ctx, ok := sharesEnv(w, r)
+	if !ok {
+		incMetric(metricAPIExportsErr)
+		return
+	}
+	job, ok := exportFromRequest(w, r, env, ctx)
+	if !ok {
+		incMetric(metricAPIExportsErr)
+		return
+	}
+	if err := queriesmgr.CancelExportJob(job.JobID, env.ID, ctx[ctxUser]); err != nil {
+		apiErrorResponse(w, "error canceling export job", http.StatusConflict, err)
+		incMetric(metricAPIExportsErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Export job %s canceled", job.JobID)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiGenericResponse{Message: fmt.Sprintf("export job %s canceled", job.JobID)})
+	incMetric(metricAPIExportsOK)
+}
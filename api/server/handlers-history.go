@@ -0,0 +1,94 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+const (
+	metricAPIHistoryReq = "history-req"
+	metricAPIHistoryErr = "history-err"
+	metricAPIHistoryOK  = "history-ok"
+)
+
+// GET Handler to return the node count history of one environment as JSON, filtered with metric, from and to
+func apiEnvHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIHistoryReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPIHistoryErr)
+		return
+	}
+	// Get environment by name
+	env, err := envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		if err.Error() == "record not found" {
+			apiErrorResponse(w, "environment not found", http.StatusNotFound, err)
+		} else {
+			apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, err)
+		}
+		incMetric(metricAPIHistoryErr)
+		return
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.UserLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPIHistoryErr)
+		return
+	}
+	q := r.URL.Query()
+	metric := q.Get("metric")
+	if metric == "" {
+		metric = nodes.HistoryTotal
+	}
+	if !nodes.ValidHistoryMetric(metric) {
+		apiErrorResponse(w, "invalid metric", http.StatusBadRequest, fmt.Errorf("metric %s", metric))
+		incMetric(metricAPIHistoryErr)
+		return
+	}
+	now := time.Now()
+	from, err := environments.ParseSince(q.Get("from"), now)
+	if err != nil {
+		apiErrorResponse(w, "invalid from", http.StatusBadRequest, err)
+		incMetric(metricAPIHistoryErr)
+		return
+	}
+	if from.IsZero() {
+		from = now.AddDate(0, 0, -nodes.DefaultHistoryDays)
+	}
+	to, err := environments.ParseSince(q.Get("to"), now)
+	if err != nil {
+		apiErrorResponse(w, "invalid to", http.StatusBadRequest, err)
+		incMetric(metricAPIHistoryErr)
+		return
+	}
+	if to.IsZero() {
+		to = now
+	}
+	history, err := nodesmgr.CountHistory(env.ID, metric, from, to)
+	if err != nil {
+		apiErrorResponse(w, "error getting history", http.StatusInternalServerError, err)
+		incMetric(metricAPIHistoryErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned %s history for %s", metric, env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, history)
+	incMetric(metricAPIHistoryOK)
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+// GET Handler for the configuration lineage of a node, rendering again the diff between ?from= and ?to= when present
+func apiNodeConfigHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPINodesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	node, ok := membershipNode(w, r)
+	if !ok {
+		incMetric(metricAPINodesErr)
+		return
+	}
+	lineage, err := envs.Lineage(node.UUID)
+	if err != nil {
+		apiErrorResponse(w, "error getting configuration lineage", http.StatusInternalServerError, err)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	history := environments.ConfigHistory{Lineage: lineage}
+	q := r.URL.Query()
+	if q.Get("from") != "" || q.Get("to") != "" {
+		from, ok := environments.FindLineage(lineage, q.Get("from"))
+		if !ok {
+			apiErrorResponse(w, "invalid from", http.StatusBadRequest, nil)
+			incMetric(metricAPINodesErr)
+			return
+		}
+		to, ok := environments.FindLineage(lineage, q.Get("to"))
+		if !ok {
+			apiErrorResponse(w, "invalid to", http.StatusBadRequest, nil)
+			incMetric(metricAPINodesErr)
+			return
+		}
+		diff, err := envs.DiffLineage(from, to)
+		if err != nil {
+			apiErrorResponse(w, "error rendering configuration lineage", http.StatusInternalServerError, err)
+			incMetric(metricAPINodesErr)
+			return
+		}
+		history.Diff = &diff
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned configuration lineage for node %s", node.UUID)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, history)
+	incMetric(metricAPINodesOK)
+}
@@ -1,4 +1,4 @@
-package main
+package server
 
 import (
 	"encoding/json"
@@ -32,7 +32,7 @@ func apiLoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// Get environment
-	env, err := envs.Get(envVar)
+	env, err := envs.GetCtx(r.Context(), envVar)
 	if err != nil {
 		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
 		incMetric(metricAPILoginErr)
@@ -45,6 +45,21 @@ func apiLoginHandler(w http.ResponseWriter, r *http.Request) {
 		incMetric(metricAPILoginErr)
 		return
 	}
+	// Count the attempt before checking credentials, delayed and locked out attempts are rejected.
+	// Errors do not prevent logins.
+	subjects := users.LoginSubjects(l.Username, utils.GetIP(r))
+	if throttle != nil {
+		wait, err := throttle.Reserve(subjects)
+		if err != nil {
+			log.Printf("error checking login attempts %v", err)
+		}
+		if wait > 0 {
+			w.Header().Set("Retry-After", users.RetryAfter(wait))
+			apiErrorResponse(w, users.ErrLoginThrottled.Error(), http.StatusTooManyRequests, nil)
+			incMetric(metricAPILoginErr)
+			return
+		}
+	}
 	// Check credentials
 	access, user := apiUsers.CheckLoginCredentials(l.Username, l.Password)
 	if !access {
@@ -52,6 +67,11 @@ func apiLoginHandler(w http.ResponseWriter, r *http.Request) {
 		incMetric(metricAPILoginErr)
 		return
 	}
+	if throttle != nil {
+		if err := throttle.Success(subjects); err != nil {
+			log.Printf("error clearing failed logins %v", err)
+		}
+	}
 	// Check if user has access to this environment
 	if !apiUsers.CheckPermissions(l.Username, users.AdminLevel, env.UUID) {
 		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", l.Username))
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+// Helper to get the node of a membership request, checking access to its environment
+func membershipNode(w http.ResponseWriter, r *http.Request) (nodes.OsqueryNode, bool) {
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		apiErrorResponse(w, "error with environment", http.StatusInternalServerError, nil)
+		return nodes.OsqueryNode{}, false
+	}
+	// Get environment
+	env, err := envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
+		return nodes.OsqueryNode{}, false
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.UserLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		return nodes.OsqueryNode{}, false
+	}
+	// Extract host identifier for node
+	nodeVar, ok := vars["node"]
+	if !ok {
+		apiErrorResponse(w, "error getting node", http.StatusInternalServerError, nil)
+		return nodes.OsqueryNode{}, false
+	}
+	node, err := nodesmgr.GetByIdentifierCtx(r.Context(), nodeVar)
+	if err != nil {
+		if err.Error() == "record not found" {
+			apiErrorResponse(w, "node not found", http.StatusNotFound, err)
+		} else {
+			apiErrorResponse(w, "error getting node", http.StatusInternalServerError, err)
+		}
+		return nodes.OsqueryNode{}, false
+	}
+	if node.EnvironmentID != env.ID {
+		apiErrorResponse(w, "node not found", http.StatusNotFound, fmt.Errorf("node %s not in environment %s", nodeVar, env.Name))
+		return nodes.OsqueryNode{}, false
+	}
+	return node, true
+}
+
+// GET Handler for the environments and tags of a node at one point in time, with ?at= or now
+func apiNodeMembershipHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPINodesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	node, ok := membershipNode(w, r)
+	if !ok {
+		incMetric(metricAPINodesErr)
+		return
+	}
+	now := time.Now()
+	at, err := environments.ParseSince(r.URL.Query().Get("at"), now)
+	if err != nil {
+		apiErrorResponse(w, "invalid at", http.StatusBadRequest, err)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	if at.IsZero() {
+		at = now
+	}
+	state, err := nodesmgr.GetMembershipAt(node.UUID, at)
+	if err != nil {
+		apiErrorResponse(w, "error getting membership", http.StatusInternalServerError, err)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned membership for node %s at %s", node.UUID, at)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, state)
+	incMetric(metricAPINodesOK)
+}
+
+// GET Handler for the changes of environments and tags of a node within ?from= and ?to=, as JSON or ?format=csv
+func apiNodeMembershipChangesHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPINodesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	node, ok := membershipNode(w, r)
+	if !ok {
+		incMetric(metricAPINodesErr)
+		return
+	}
+	q := r.URL.Query()
+	now := time.Now()
+	from, err := environments.ParseSince(q.Get("from"), now)
+	if err != nil {
+		apiErrorResponse(w, "invalid from", http.StatusBadRequest, err)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	to, err := environments.ParseSince(q.Get("to"), now)
+	if err != nil {
+		apiErrorResponse(w, "invalid to", http.StatusBadRequest, err)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	if to.IsZero() {
+		to = now
+	}
+	if from.After(to) {
+		apiErrorResponse(w, "from is after to", http.StatusBadRequest, nil)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	changes, err := nodesmgr.GetMembershipChanges(node.UUID, from, to)
+	if err != nil {
+		apiErrorResponse(w, "error getting membership changes", http.StatusInternalServerError, err)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned %d membership changes for node %s", len(changes), node.UUID)
+	}
+	if q.Get("format") != "csv" {
+		utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, changes)
+		incMetric(metricAPINodesOK)
+		return
+	}
+	filename := fmt.Sprintf("membership-%s-%s.csv", node.UUID, now.UTC().Format("20060102-150405"))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Header().Set(utils.ContentType, "text/csv")
+	w.WriteHeader(http.StatusOK)
+	counter := &environments.AccessCounter{ResponseWriter: w}
+	cw := csv.NewWriter(counter)
+	_ = cw.Write([]string{"timestamp", "uuid", "node_id", "entity", "name", "action", "actor"})
+	for _, c := range changes {
+		_ = cw.Write([]string{
+			c.CreatedAt.UTC().Format(time.RFC3339),
+			c.UUID,
+			strconv.FormatUint(uint64(c.NodeID), 10),
+			c.Entity,
+			c.Name,
+			c.Action,
+			c.Actor,
+		})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		log.Printf("error exporting membership changes %v", err)
+	}
+	recordAccess(r, node.EnvironmentID, environments.AccessNodeExport, node.UUID, "membership changes", counter.Bytes)
+	incMetric(metricAPINodesOK)
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+// Helper to get the thresholds to propose duplicate nodes from settings
+func apiMergeThresholds() nodes.MergeThresholds {
+	return nodes.NewMergeThresholds(settingsmgr.DuplicateScore(), settingsmgr.DuplicateHostnameDistance())
+}
+
+// GET Handler for merges of duplicate nodes, filtered by state with ?state=
+func apiNodeMergesHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPINodesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, _, ok := envWithAccess(w, r, users.UserLevel)
+	if !ok {
+		incMetric(metricAPINodesErr)
+		return
+	}
+	state := r.URL.Query().Get("state")
+	switch state {
+	case "", nodes.MergeProposed, nodes.MergeRejected, nodes.MergeMerged, nodes.MergeSplit:
+	default:
+		apiErrorResponse(w, "invalid state", http.StatusBadRequest, fmt.Errorf("invalid state %s", state))
+		incMetric(metricAPINodesErr)
+		return
+	}
+	merges, err := nodesmgr.GetMerges(env.ID, state)
+	if err != nil {
+		apiErrorResponse(w, "error getting node merges", http.StatusInternalServerError, err)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned %d node merges", len(merges))
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, merges)
+	incMetric(metricAPINodesOK)
+}
+
+// POST Handler to merge a duplicate node into the canonical node, without review
+func apiNodeMergeHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPINodesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, user, ok := envWithAccess(w, r, users.AdminLevel)
+	if !ok {
+		incMetric(metricAPINodesErr)
+		return
+	}
+	var m types.ApiNodeMergeRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Both nodes must be in the environment
+	for _, uuid := range []string{m.Into, m.From} {
+		if _, err := nodesmgr.GetByUUIDEnv(uuid, env.ID); err != nil {
+			apiErrorResponse(w, "node not found", http.StatusNotFound, err)
+			incMetric(metricAPINodesErr)
+			return
+		}
+	}
+	merge, err := nodesmgr.ManualMerge(m.Into, m.From, user, apiMergeThresholds())
+	if err != nil {
+		apiErrorResponse(w, "error merging nodes", http.StatusBadRequest, err)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	recordActivity(env, environments.ActivityMerges, user, nodes.MergeSummary(merge), "", merge.Evidence)
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Merged node %s into %s", m.From, m.Into)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, merge)
+	incMetric(metricAPINodesOK)
+}
+
+// POST Handler to approve, reject or split a merge of duplicate nodes
+func apiNodeMergeReviewHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPINodesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, user, ok := envWithAccess(w, r, users.AdminLevel)
+	if !ok {
+		incMetric(metricAPINodesErr)
+		return
+	}
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		apiErrorResponse(w, "invalid merge", http.StatusBadRequest, err)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	merge, err := nodesmgr.GetMerge(uint(id))
+	if err != nil || merge.EnvironmentID != env.ID {
+		apiErrorResponse(w, "merge not found", http.StatusNotFound, err)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	var m types.ApiNodeMergeReviewRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	switch m.Action {
+	case "approve":
+		merge, err = nodesmgr.ApproveMerge(merge.ID, user)
+	case "reject":
+		merge, err = nodesmgr.RejectMerge(merge.ID, user)
+	case "split":
+		merge, err = nodesmgr.SplitMerge(merge.ID, user, nodes.MergeWindow(settingsmgr.MergeSplitDays()))
+	default:
+		apiErrorResponse(w, "invalid action", http.StatusBadRequest, fmt.Errorf("action %s", m.Action))
+		incMetric(metricAPINodesErr)
+		return
+	}
+	if err != nil {
+		apiErrorResponse(w, "error with node merge", http.StatusBadRequest, err)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	recordActivity(env, environments.ActivityMerges, user, nodes.MergeSummary(merge), "", merge.Evidence)
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Node merge %d is %s", merge.ID, merge.State)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, merge)
+	incMetric(metricAPINodesOK)
+}
@@ -0,0 +1,537 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+const (
+	metricAPINodesReq = "nodes-req"
+	metricAPINodesErr = "nodes-err"
+	metricAPINodesOK  = "nodes-ok"
+)
+
+// GET Handler for single JSON nodes
+func apiNodeHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPINodesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		apiErrorResponse(w, "error with environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Get environment
+	env, err := envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.UserLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Extract host identifier for node
+	nodeVar, ok := vars["node"]
+	if !ok {
+		apiErrorResponse(w, "error getting node", http.StatusInternalServerError, nil)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Get node by identifier
+	// FIXME keep a cache of nodes by node identifier
+	node, err := nodesmgr.GetByIdentifierCtx(r.Context(), nodeVar)
+	if err != nil {
+		if err.Error() == "record not found" {
+			apiErrorResponse(w, "node not found", http.StatusNotFound, err)
+		} else {
+			apiErrorResponse(w, "error getting node", http.StatusInternalServerError, err)
+		}
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Clients with the node as of its last update get 304
+	if notModified(w, r, fmt.Sprintf(`"node-%d-%d"`, node.ID, node.UpdatedAt.UnixNano())) {
+		incMetric(metricAPINodesOK)
+		return
+	}
+	// Include the network history in the export of the node
+	node.NetworkHistory, err = nodesmgr.GetHistoryIPAddresses(node.UUID)
+	if err != nil {
+		apiErrorResponse(w, "error getting network history", http.StatusInternalServerError, err)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned node %s", nodeVar)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, node)
+	incMetric(metricAPINodesOK)
+}
+
+// Helper to filter nodes by enroll metadata, with ?meta=name:value parameters
+func filterNodesByMetadata(nds []nodes.OsqueryNode, r *http.Request) []nodes.OsqueryNode {
+	filters := make(map[string]string)
+	for _, f := range r.URL.Query()["meta"] {
+		if name, value, ok := nodes.ParseMetadataFilter(f); ok {
+			filters[name] = value
+		}
+	}
+	return nodes.FilterByEnrollMetadata(nds, filters)
+}
+
+// Helper to filter nodes by custom attributes with ?attr=name>value parameters, and to sort them by
+// the value of one attribute with ?sort=attr:name
+func filterNodesByAttributes(nds []nodes.OsqueryNode, r *http.Request) ([]nodes.OsqueryNode, error) {
+	var filters []nodes.AttributeFilter
+	for _, f := range r.URL.Query()["attr"] {
+		filter, err := nodes.ParseAttributeFilter(f)
+		if err != nil {
+			return nds, err
+		}
+		filters = append(filters, filter)
+	}
+	sortBy := r.URL.Query().Get("sort")
+	if len(filters) == 0 && !strings.HasPrefix(sortBy, nodes.AttributeFilterPrefix) {
+		return nds, nil
+	}
+	attrs, err := nodesmgr.AttributesOfNodes(nds)
+	if err != nil {
+		return nds, err
+	}
+	nds = nodes.FilterByAttributes(nds, attrs, filters)
+	if strings.HasPrefix(sortBy, nodes.AttributeFilterPrefix) {
+		nodes.SortByAttribute(nds, attrs, strings.ToLower(strings.TrimPrefix(sortBy, nodes.AttributeFilterPrefix)))
+	}
+	return nds, nil
+}
+
+// GET Handler for active JSON nodes
+func apiActiveNodesHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPINodesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		apiErrorResponse(w, "error with environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Get environment
+	env, err := envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.AdminLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Get nodes
+	nodes, err := nodesmgr.Gets("active", 24)
+	if err != nil {
+		apiErrorResponse(w, "error getting nodes", http.StatusInternalServerError, err)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	nodes, err = filterNodesByAttributes(filterNodesByMetadata(nodes, r), r)
+	if err != nil {
+		apiErrorResponse(w, "invalid attribute filter", http.StatusBadRequest, err)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	if len(nodes) == 0 {
+		apiErrorResponse(w, "no nodes", http.StatusNotFound, nil)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Println("DebugService: Returned nodes")
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, nodes)
+	incMetric(metricAPINodesOK)
+}
+
+// GET Handler for inactive JSON nodes
+func apiInactiveNodesHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPINodesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		apiErrorResponse(w, "error with environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Get environment
+	env, err := envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.AdminLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Get nodes
+	nodes, err := nodesmgr.Gets("inactive", 24)
+	if err != nil {
+		apiErrorResponse(w, "error getting nodes", http.StatusInternalServerError, err)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	nodes, err = filterNodesByAttributes(filterNodesByMetadata(nodes, r), r)
+	if err != nil {
+		apiErrorResponse(w, "invalid attribute filter", http.StatusBadRequest, err)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	if len(nodes) == 0 {
+		apiErrorResponse(w, "no nodes", http.StatusNotFound, nil)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Println("DebugService: Returned nodes")
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, nodes)
+	incMetric(metricAPINodesOK)
+}
+
+// GET Handler for all JSON nodes
+func apiAllNodesHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPINodesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		apiErrorResponse(w, "error with environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Get environment
+	env, err := envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.AdminLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Get nodes
+	nodes, err := nodesmgr.Gets("all", 0)
+	if err != nil {
+		apiErrorResponse(w, "error getting nodes", http.StatusInternalServerError, err)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	nodes, err = filterNodesByAttributes(filterNodesByMetadata(nodes, r), r)
+	if err != nil {
+		apiErrorResponse(w, "invalid attribute filter", http.StatusBadRequest, err)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	if len(nodes) == 0 {
+		apiErrorResponse(w, "no nodes", http.StatusNotFound, nil)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Println("DebugService: Returned nodes")
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, nodes)
+	incMetric(metricAPINodesOK)
+}
+
+// POST Handler to delete single node
+func apiDeleteNodeHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPINodesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		apiErrorResponse(w, "error with environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Get environment
+	env, err := envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.AdminLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPINodesErr)
+		return
+	}
+	var n types.ApiNodeGenericRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	if err := nodesmgr.ArchiveDeleteByUUID(n.UUID, ctx[ctxUser]); err != nil {
+		if err.Error() == "record not found" {
+			apiErrorResponse(w, "node not found", http.StatusNotFound, err)
+		} else {
+			apiErrorResponse(w, "error getting node", http.StatusInternalServerError, err)
+		}
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned node %s", n.UUID)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiGenericResponse{Message: "node deleted"})
+	incMetric(metricAPINodesOK)
+}
+
+// GET Handler for the network history of a node
+func apiNodeNetworkHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPINodesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		apiErrorResponse(w, "error with environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Get environment
+	env, err := envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.UserLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Extract host identifier for node
+	nodeVar, ok := vars["node"]
+	if !ok {
+		apiErrorResponse(w, "error getting node", http.StatusInternalServerError, nil)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Get node by identifier
+	node, err := nodesmgr.GetByIdentifierCtx(r.Context(), nodeVar)
+	if err != nil {
+		if err.Error() == "record not found" {
+			apiErrorResponse(w, "node not found", http.StatusNotFound, err)
+		} else {
+			apiErrorResponse(w, "error getting node", http.StatusInternalServerError, err)
+		}
+		incMetric(metricAPINodesErr)
+		return
+	}
+	if node.EnvironmentID != env.ID {
+		apiErrorResponse(w, "node not found", http.StatusNotFound, fmt.Errorf("node %s not in environment %s", nodeVar, env.Name))
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Get network history
+	history, err := nodesmgr.GetHistoryIPAddresses(node.UUID)
+	if err != nil {
+		apiErrorResponse(w, "error getting network history", http.StatusInternalServerError, err)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned network history for node %s", nodeVar)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, history)
+	incMetric(metricAPINodesOK)
+}
+
+// POST Handler to decommission a node, osquery is removed and the node archived once it stops contacting
+func apiDecommissionNodeHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPINodesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		apiErrorResponse(w, "error with environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Get environment
+	env, err := envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.AdminLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPINodesErr)
+		return
+	}
+	var n types.ApiNodeGenericRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Node must be in the environment
+	if _, err := nodesmgr.GetByUUIDEnv(n.UUID, env.ID); err != nil {
+		if err.Error() == "record not found" {
+			apiErrorResponse(w, "node not found", http.StatusNotFound, err)
+		} else {
+			apiErrorResponse(w, "error getting node", http.StatusInternalServerError, err)
+		}
+		incMetric(metricAPINodesErr)
+		return
+	}
+	decommission, err := nodesmgr.Decommission(n.UUID, ctx[ctxUser])
+	if err != nil {
+		apiErrorResponse(w, "error decommissioning node", http.StatusInternalServerError, err)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Decommissioned node %s", n.UUID)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, decommission)
+	incMetric(metricAPINodesOK)
+}
+
+// GET Handler for decommissioned nodes, filtered by state with ?state=
+func apiDecommissionsHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPINodesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		apiErrorResponse(w, "error with environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Get environment
+	env, err := envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.UserLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPINodesErr)
+		return
+	}
+	state := r.URL.Query().Get("state")
+	switch state {
+	case "", nodes.DecommissionPending, nodes.DecommissionSilent, nodes.DecommissionContacting:
+	default:
+		apiErrorResponse(w, "invalid state", http.StatusBadRequest, fmt.Errorf("invalid state %s", state))
+		incMetric(metricAPINodesErr)
+		return
+	}
+	decommissions, err := nodesmgr.GetDecommissions(env.ID, state)
+	if err != nil {
+		apiErrorResponse(w, "error getting decommissioned nodes", http.StatusInternalServerError, err)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned %d decommissioned nodes", len(decommissions))
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, decommissions)
+	incMetric(metricAPINodesOK)
+}
+
+// GET Handler to search nodes by identifier across the environments the user can access
+func apiSearchNodesHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPINodesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	term := r.URL.Query().Get("q")
+	if len(strings.TrimSpace(term)) < nodes.MinSearchLength {
+		apiErrorResponse(w, fmt.Sprintf("search term must be at least %d characters", nodes.MinSearchLength), http.StatusBadRequest, nil)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	envAll, err := envs.AllCtx(r.Context())
+	if err != nil {
+		apiErrorResponse(w, "error getting environments", http.StatusInternalServerError, err)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Only environments with access are searched
+	var envIDs []uint
+	for _, e := range envAll {
+		if checkAccess(ctx, users.UserLevel, e.UUID) {
+			envIDs = append(envIDs, e.ID)
+		}
+	}
+	matches, err := nodesmgr.Search(r.Context(), term, envIDs, nodes.DefaultSearchLimit)
+	if err != nil {
+		apiErrorResponse(w, "error searching nodes", http.StatusInternalServerError, err)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned %d nodes searching %s", len(matches), term)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, matches)
+	incMetric(metricAPINodesOK)
+}
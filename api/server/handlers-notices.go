@@ -0,0 +1,271 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+const (
+	metricAPINoticesReq = "notices-req"
+	metricAPINoticesErr = "notices-err"
+	metricAPINoticesOK  = "notices-ok"
+)
+
+// Helper to check that the user of a request can manage notices, which are not limited to an environment
+func noticesAccess(w http.ResponseWriter, r *http.Request) (string, bool) {
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.AdminLevel, users.NoEnvironment) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		return "", false
+	}
+	return ctx[ctxUser], true
+}
+
+// Helper to get the notice of a request by its ID
+func noticeID(w http.ResponseWriter, r *http.Request) (uint, bool) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		apiErrorResponse(w, "invalid notice", http.StatusBadRequest, err)
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// Helper to prepare a notice from a request, environments by name or UUID
+func noticeFromRequest(n types.ApiNoticeRequest, creator string) (users.Notice, error) {
+	notice := users.Notice{
+		Message:        n.Message,
+		Severity:       n.Severity,
+		RequireAck:     n.RequireAck,
+		ConfirmActions: n.ConfirmActions,
+		Creator:        creator,
+	}
+	expires, err := users.ParseNoticeExpiry(n.ExpiresAt, time.Now())
+	if err != nil {
+		return notice, err
+	}
+	notice.ExpiresAt = expires
+	var envUUIDs []string
+	for _, e := range n.Environments {
+		env, err := envs.Get(e)
+		if err != nil {
+			return notice, fmt.Errorf("error getting environment %s - %v", e, err)
+		}
+		envUUIDs = append(envUUIDs, env.UUID)
+	}
+	notice.Environments = strings.Join(envUUIDs, ",")
+	return notice, nil
+}
+
+// GET Handler to return the active notices, only the ones shown in an environment with ?env= and
+// all of them with the expired and removed ones with ?history=true
+func apiNoticesHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPINoticesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	var notices []users.Notice
+	var err error
+	if r.URL.Query().Get("history") == "true" {
+		if _, ok := noticesAccess(w, r); !ok {
+			incMetric(metricAPINoticesErr)
+			return
+		}
+		notices, err = apiUsers.NoticesHistory()
+	} else {
+		notices, err = apiUsers.ActiveNotices(time.Now())
+	}
+	if err != nil {
+		apiErrorResponse(w, "error getting notices", http.StatusInternalServerError, err)
+		incMetric(metricAPINoticesErr)
+		return
+	}
+	if envVar := r.URL.Query().Get("env"); envVar != "" {
+		env, err := envs.GetCtx(r.Context(), envVar)
+		if err != nil {
+			apiErrorResponse(w, "error getting environment", http.StatusNotFound, err)
+			incMetric(metricAPINoticesErr)
+			return
+		}
+		inScope := []users.Notice{}
+		for _, n := range notices {
+			if n.InScope(env.UUID) {
+				inScope = append(inScope, n)
+			}
+		}
+		notices = inScope
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Println("DebugService: Returned notices")
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, notices)
+	incMetric(metricAPINoticesOK)
+}
+
+// POST Handler to create a notice
+func apiNewNoticeHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPINoticesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	actor, ok := noticesAccess(w, r)
+	if !ok {
+		incMetric(metricAPINoticesErr)
+		return
+	}
+	var n types.ApiNoticeRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusBadRequest, err)
+		incMetric(metricAPINoticesErr)
+		return
+	}
+	notice, err := noticeFromRequest(n, actor)
+	if err != nil {
+		apiErrorResponse(w, "invalid notice", http.StatusBadRequest, err)
+		incMetric(metricAPINoticesErr)
+		return
+	}
+	created, err := apiUsers.NewNotice(notice)
+	if err != nil {
+		apiErrorResponse(w, "error creating notice", http.StatusBadRequest, err)
+		incMetric(metricAPINoticesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Notice %d created", created.ID)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, created)
+	incMetric(metricAPINoticesOK)
+}
+
+// POST Handler to change a notice
+func apiUpdateNoticeHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPINoticesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	actor, ok := noticesAccess(w, r)
+	if !ok {
+		incMetric(metricAPINoticesErr)
+		return
+	}
+	id, ok := noticeID(w, r)
+	if !ok {
+		incMetric(metricAPINoticesErr)
+		return
+	}
+	var n types.ApiNoticeRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusBadRequest, err)
+		incMetric(metricAPINoticesErr)
+		return
+	}
+	notice, err := noticeFromRequest(n, actor)
+	if err != nil {
+		apiErrorResponse(w, "invalid notice", http.StatusBadRequest, err)
+		incMetric(metricAPINoticesErr)
+		return
+	}
+	updated, err := apiUsers.UpdateNotice(id, notice)
+	if err != nil {
+		apiErrorResponse(w, "error updating notice", http.StatusBadRequest, err)
+		incMetric(metricAPINoticesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Notice %d updated", updated.ID)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, updated)
+	incMetric(metricAPINoticesOK)
+}
+
+// POST Handler to remove a notice, it is kept in the history
+func apiDeleteNoticeHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPINoticesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	actor, ok := noticesAccess(w, r)
+	if !ok {
+		incMetric(metricAPINoticesErr)
+		return
+	}
+	id, ok := noticeID(w, r)
+	if !ok {
+		incMetric(metricAPINoticesErr)
+		return
+	}
+	if err := apiUsers.DeleteNotice(id, actor); err != nil {
+		apiErrorResponse(w, "error removing notice", http.StatusNotFound, err)
+		incMetric(metricAPINoticesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Notice %d removed", id)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiGenericResponse{Message: fmt.Sprintf("notice %d removed", id)})
+	incMetric(metricAPINoticesOK)
+}
+
+// POST Handler to acknowledge a notice by the user of the request
+func apiAckNoticeHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPINoticesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	id, ok := noticeID(w, r)
+	if !ok {
+		incMetric(metricAPINoticesErr)
+		return
+	}
+	if err := apiUsers.AckNotice(id, ctx[ctxUser]); err != nil {
+		apiErrorResponse(w, "error acknowledging notice", http.StatusNotFound, err)
+		incMetric(metricAPINoticesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Notice %d acknowledged by %s", id, ctx[ctxUser])
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiGenericResponse{Message: fmt.Sprintf("notice %d acknowledged", id)})
+	incMetric(metricAPINoticesOK)
+}
+
+// GET Handler to return the acknowledgments of a notice
+func apiNoticeAcksHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPINoticesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	if _, ok := noticesAccess(w, r); !ok {
+		incMetric(metricAPINoticesErr)
+		return
+	}
+	id, ok := noticeID(w, r)
+	if !ok {
+		incMetric(metricAPINoticesErr)
+		return
+	}
+	acks, err := apiUsers.NoticeAcks()
+	if err != nil {
+		apiErrorResponse(w, "error getting acknowledgments", http.StatusInternalServerError, err)
+		incMetric(metricAPINoticesErr)
+		return
+	}
+	noticeAcks := acks[id]
+	if noticeAcks == nil {
+		noticeAcks = []users.NoticeAck{}
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned acknowledgments of notice %d", id)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, noticeAcks)
+	incMetric(metricAPINoticesOK)
+}
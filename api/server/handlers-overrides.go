@@ -0,0 +1,132 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+// POST Handler to override the interval of one scheduled query for a node
+func apiScheduleOverrideHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPINodesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		apiErrorResponse(w, "error with environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Get environment
+	env, err := envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		if err.Error() == "record not found" {
+			apiErrorResponse(w, "environment not found", http.StatusNotFound, err)
+		} else {
+			apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, err)
+		}
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Get context data and check access, overrides change the configuration of nodes
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.AdminLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Extract host identifier for node
+	nodeVar, ok := vars["node"]
+	if !ok {
+		apiErrorResponse(w, "error getting node", http.StatusInternalServerError, nil)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Get node by identifier
+	node, err := nodesmgr.GetByIdentifierCtx(r.Context(), nodeVar)
+	if err != nil {
+		if err.Error() == "record not found" {
+			apiErrorResponse(w, "node not found", http.StatusNotFound, err)
+		} else {
+			apiErrorResponse(w, "error getting node", http.StatusInternalServerError, err)
+		}
+		incMetric(metricAPINodesErr)
+		return
+	}
+	if node.EnvironmentID != env.ID {
+		apiErrorResponse(w, "node not found", http.StatusNotFound, fmt.Errorf("node %s not in environment %s", nodeVar, env.Name))
+		incMetric(metricAPINodesErr)
+		return
+	}
+	var o types.ApiScheduleOverrideRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&o); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	override, err := envs.SetScheduleOverride(env, node.UUID, node.Platform, o.Query, o.Interval, o.ExpiresAt, ctx[ctxUser])
+	if err != nil {
+		apiErrorResponse(w, "error setting schedule override", http.StatusBadRequest, err)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Schedule override for %s in node %s", o.Query, nodeVar)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, override)
+	incMetric(metricAPINodesOK)
+}
+
+// GET Handler for the active schedule overrides of all nodes in an environment
+func apiScheduleOverridesHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPINodesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		apiErrorResponse(w, "error with environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Get environment
+	env, err := envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		if err.Error() == "record not found" {
+			apiErrorResponse(w, "environment not found", http.StatusNotFound, err)
+		} else {
+			apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, err)
+		}
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.UserLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPINodesErr)
+		return
+	}
+	overrides, err := envs.EnvScheduleOverrides(env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting schedule overrides", http.StatusInternalServerError, err)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned schedule overrides for %s", env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, overrides)
+	incMetric(metricAPINodesOK)
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+const (
+	metricAPIPackSyncReq = "packsync-req"
+	metricAPIPackSyncErr = "packsync-err"
+	metricAPIPackSyncOK  = "packsync-ok"
+)
+
+// GET Handler to return the pack source of one environment with its synced packs and conflicts
+func apiPackSourceHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIPackSyncReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, _, ok := envWithAccess(w, r, users.AdminLevel)
+	if !ok {
+		incMetric(metricAPIPackSyncErr)
+		return
+	}
+	status, err := envs.PackSyncStatus(env.ID)
+	if errors.Is(err, environments.ErrNoPackSource) {
+		apiErrorResponse(w, "environment has no pack source", http.StatusNotFound, nil)
+		incMetric(metricAPIPackSyncErr)
+		return
+	}
+	if err != nil {
+		apiErrorResponse(w, "error getting pack source", http.StatusInternalServerError, err)
+		incMetric(metricAPIPackSyncErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned pack source for %s", env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, status)
+	incMetric(metricAPIPackSyncOK)
+}
+
+// POST Handler to create or update the pack source of one environment, it is synced right away when enabled
+func apiSetPackSourceHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIPackSyncReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, actor, ok := envWithAccess(w, r, users.AdminLevel)
+	if !ok {
+		incMetric(metricAPIPackSyncErr)
+		return
+	}
+	var req types.ApiPackSourceRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusBadRequest, err)
+		incMetric(metricAPIPackSyncErr)
+		return
+	}
+	before, _ := envs.GetPackSource(env.ID)
+	source, err := envs.SetPackSource(environments.PackSource{
+		EnvironmentID: env.ID,
+		URL:           req.URL,
+		Branch:        req.Branch,
+		PathGlob:      req.PathGlob,
+		AuthKind:      req.AuthKind,
+		Credential:    req.Credential,
+		Interval:      req.Interval,
+		Enabled:       req.Enabled,
+		CreatedBy:     actor,
+	})
+	if err != nil {
+		apiErrorResponse(w, "error setting pack source", http.StatusBadRequest, err)
+		incMetric(metricAPIPackSyncErr)
+		return
+	}
+	recordActivity(env, environments.ActivityPackSync, actor, "pack source set to "+source.URL+"@"+source.Branch, before.String(), source.String())
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Pack source of %s set to %s", env.Name, source.URL)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, source)
+	incMetric(metricAPIPackSyncOK)
+}
+
+// POST Handler to delete the pack source of one environment, the packs synced from it are kept
+func apiDeletePackSourceHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIPackSyncReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, actor, ok := envWithAccess(w, r, users.AdminLevel)
+	if !ok {
+		incMetric(metricAPIPackSyncErr)
+		return
+	}
+	before, _ := envs.GetPackSource(env.ID)
+	err := envs.DeletePackSource(env.ID)
+	if errors.Is(err, environments.ErrNoPackSource) {
+		apiErrorResponse(w, "environment has no pack source", http.StatusNotFound, nil)
+		incMetric(metricAPIPackSyncErr)
+		return
+	}
+	if err != nil {
+		apiErrorResponse(w, "error deleting pack source", http.StatusInternalServerError, err)
+		incMetric(metricAPIPackSyncErr)
+		return
+	}
+	recordActivity(env, environments.ActivityPackSync, actor, "pack source deleted", before.String(), "")
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Pack source of %s deleted", env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiGenericResponse{Message: "pack source deleted"})
+	incMetric(metricAPIPackSyncOK)
+}
+
+// POST Handler to sync the pack source of one environment at the next check, syncs run in the background
+func apiSyncPacksHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIPackSyncReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, actor, ok := envWithAccess(w, r, users.AdminLevel)
+	if !ok {
+		incMetric(metricAPIPackSyncErr)
+		return
+	}
+	err := envs.RequestPackSync(env.ID, actor)
+	if errors.Is(err, environments.ErrNoPackSource) {
+		apiErrorResponse(w, "environment has no enabled pack source", http.StatusNotFound, nil)
+		incMetric(metricAPIPackSyncErr)
+		return
+	}
+	if err != nil {
+		apiErrorResponse(w, "error requesting pack sync", http.StatusInternalServerError, err)
+		incMetric(metricAPIPackSyncErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Pack sync of %s requested", env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiGenericResponse{Message: "pack sync requested"})
+	incMetric(metricAPIPackSyncOK)
+}
+
+// POST Handler to choose if the repository or the manual version wins for a synced pack in conflict
+func apiResolvePackHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIPackSyncReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, actor, ok := envWithAccess(w, r, users.AdminLevel)
+	if !ok {
+		incMetric(metricAPIPackSyncErr)
+		return
+	}
+	var req types.ApiPackResolveRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusBadRequest, err)
+		incMetric(metricAPIPackSyncErr)
+		return
+	}
+	if err := envs.ResolvePackConflict(env.ID, req.Pack, req.Resolution, actor); err != nil {
+		apiErrorResponse(w, "error resolving pack conflict", http.StatusBadRequest, err)
+		incMetric(metricAPIPackSyncErr)
+		return
+	}
+	msg := "pack " + req.Pack + " managed manually"
+	if req.Resolution == environments.PackRepoWins {
+		msg = "pack " + req.Pack + " synced from the repository"
+	} else if req.Resolution == "" {
+		msg = "conflicts of pack " + req.Pack + " need to be resolved"
+	}
+	recordActivity(env, environments.ActivityPackSync, actor, msg, "", "")
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: %s in %s", msg, env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiGenericResponse{Message: msg})
+	incMetric(metricAPIPackSyncOK)
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+// Helper to get the node of the request in the environment with the access level
+func pauseNodeFromRequest(w http.ResponseWriter, r *http.Request) (environments.TLSEnvironment, nodes.OsqueryNode, string, bool) {
+	env, actor, ok := envWithAccess(w, r, users.AdminLevel)
+	if !ok {
+		return env, nodes.OsqueryNode{}, actor, false
+	}
+	nodeVar := mux.Vars(r)["node"]
+	node, err := nodesmgr.GetByIdentifierCtx(r.Context(), nodeVar)
+	if err != nil {
+		if err.Error() == "record not found" {
+			apiErrorResponse(w, "node not found", http.StatusNotFound, err)
+		} else {
+			apiErrorResponse(w, "error getting node", http.StatusInternalServerError, err)
+		}
+		return env, node, actor, false
+	}
+	if node.EnvironmentID != env.ID {
+		apiErrorResponse(w, "node not found", http.StatusNotFound, fmt.Errorf("node %s not in environment %s", nodeVar, env.Name))
+		return env, node, actor, false
+	}
+	return env, node, actor, true
+}
+
+// POST Handler to pause a node, it keeps sending logs but does not get queries nor carves
+func apiNodePauseHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPINodesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, node, actor, ok := pauseNodeFromRequest(w, r)
+	if !ok {
+		incMetric(metricAPINodesErr)
+		return
+	}
+	var p types.ApiNodePauseRequest
+	// Parse request JSON body, reason and expiration are optional
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil && err != io.EOF {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	expires, err := users.ParseNoticeExpiry(p.ExpiresAt, time.Now())
+	if err != nil {
+		apiErrorResponse(w, "invalid expiration", http.StatusBadRequest, err)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	pause, err := nodesmgr.Pause(node.UUID, p.Reason, actor, expires)
+	if err != nil {
+		apiErrorResponse(w, "error pausing node", http.StatusBadRequest, err)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	recordActivity(env, environments.ActivityPauses, actor, nodes.PauseSummary(pause), "", "")
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Paused node %s", node.UUID)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, pause)
+	incMetric(metricAPINodesOK)
+}
+
+// POST Handler to unpause a node, the queries still active are served again
+func apiNodeUnpauseHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPINodesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, node, actor, ok := pauseNodeFromRequest(w, r)
+	if !ok {
+		incMetric(metricAPINodesErr)
+		return
+	}
+	pause, err := nodesmgr.Unpause(node.UUID, actor)
+	if err != nil {
+		apiErrorResponse(w, "error unpausing node", http.StatusConflict, err)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	recordActivity(env, environments.ActivityPauses, actor, nodes.PauseSummary(pause), "", "")
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Unpaused node %s", node.UUID)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, pause)
+	incMetric(metricAPINodesOK)
+}
+
+// GET Handler for the paused nodes of an environment
+func apiNodePausesHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPINodesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, _, ok := envWithAccess(w, r, users.QueryLevel)
+	if !ok {
+		incMetric(metricAPINodesErr)
+		return
+	}
+	pauses, err := nodesmgr.GetPauses(env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting paused nodes", http.StatusInternalServerError, err)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned %d paused nodes", len(pauses))
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, pauses)
+	incMetric(metricAPINodesOK)
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+const (
+	metricAPIPlatformsReq = "platforms-req"
+	metricAPIPlatformsErr = "platforms-err"
+	metricAPIPlatformsOK  = "platforms-ok"
+)
+
+// GET Handler for multiple JSON platforms
+func apiPlatformsHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIPlatformsReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.AdminLevel, users.NoEnvironment) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPIPlatformsErr)
+		return
+	}
+	// Get platforms
+	platforms, err := nodesmgr.GetAllPlatforms()
+	if err != nil {
+		apiErrorResponse(w, "error getting platforms", http.StatusInternalServerError, err)
+		incMetric(metricAPIPlatformsErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Println("DebugService: Returned platforms")
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, platforms)
+	incMetric(metricAPIPlatformsOK)
+}
+
+// GET Handler for JSON platforms of one environment
+func apiEnvPlatformsHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIPlatformsReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPIPlatformsErr)
+		return
+	}
+	// Get environment by name
+	env, err := envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		if err.Error() == "record not found" {
+			apiErrorResponse(w, "environment not found", http.StatusNotFound, err)
+		} else {
+			apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, err)
+		}
+		incMetric(metricAPIPlatformsErr)
+		return
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.UserLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPIPlatformsErr)
+		return
+	}
+	// Get platforms
+	platforms, err := nodesmgr.GetEnvPlatforms(env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting platforms", http.StatusInternalServerError, err)
+		incMetric(metricAPIPlatformsErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned platforms for %s", env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, platforms)
+	incMetric(metricAPIPlatformsOK)
+}
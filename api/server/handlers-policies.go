@@ -0,0 +1,180 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/queries"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+// Helper to describe a query policy for the activity of the environment
+func policySummary(p queries.QueryPolicy) string {
+	if p.Subject == "" {
+		return ""
+	}
+	return fmt.Sprintf("mode: %s\ntables: %s\ncolumns: %s", p.Mode, p.Tables, p.Columns)
+}
+
+// GET Handler to get the query policies of an environment
+func apiQueryPoliciesHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIQueriesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		apiErrorResponse(w, "error with environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Get environment
+	env, err := envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.QueryLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	policies, err := queriesmgr.GetPolicies(env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting query policies", http.StatusInternalServerError, err)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned %d query policies", len(policies))
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, policies)
+	incMetric(metricAPIQueriesOK)
+}
+
+// POST Handler to set the query policy of a subject in an environment
+func apiSetQueryPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIQueriesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		apiErrorResponse(w, "error with environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Get environment
+	env, err := envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.AdminLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	var p types.ApiQueryPolicyRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	before, _, err := queriesmgr.PolicyFor(env.ID, p.Subject)
+	if err != nil {
+		apiErrorResponse(w, "error getting query policy", http.StatusInternalServerError, err)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	if before.Subject != p.Subject {
+		before = queries.QueryPolicy{}
+	}
+	policy, err := queriesmgr.SetPolicy(env.ID, queries.QueryPolicy{
+		Subject: p.Subject,
+		Mode:    p.Mode,
+		Tables:  strings.Join(p.Tables, ","),
+		Columns: strings.Join(p.Columns, ","),
+	}, ctx[ctxUser])
+	if err != nil {
+		apiErrorResponse(w, "error setting query policy", http.StatusBadRequest, err)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	recordActivity(env, environments.ActivityPolicies, ctx[ctxUser], "query policy for "+policy.Subject+" set", policySummary(before), policySummary(policy))
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Query policy for %s set", policy.Subject)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiGenericResponse{Message: "query policy for " + policy.Subject + " set"})
+	incMetric(metricAPIQueriesOK)
+}
+
+// POST Handler to delete the query policy of a subject in an environment
+func apiDeleteQueryPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIQueriesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		apiErrorResponse(w, "error with environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Get environment
+	env, err := envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.AdminLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	var p types.ApiQueryPolicyRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	before, _, err := queriesmgr.PolicyFor(env.ID, p.Subject)
+	if err != nil {
+		apiErrorResponse(w, "error getting query policy", http.StatusInternalServerError, err)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	if err := queriesmgr.DeletePolicy(env.ID, p.Subject); err != nil {
+		apiErrorResponse(w, "error deleting query policy", http.StatusNotFound, err)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	recordActivity(env, environments.ActivityPolicies, ctx[ctxUser], "query policy for "+p.Subject+" deleted", policySummary(before), "")
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Query policy for %s deleted", p.Subject)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiGenericResponse{Message: "query policy for " + p.Subject + " deleted"})
+	incMetric(metricAPIQueriesOK)
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	metricAPIProfilesReq = "profiles-req"
+	metricAPIProfilesErr = "profiles-err"
+	metricAPIProfilesOK  = "profiles-ok"
+)
+
+// GET Handler to return the performance profiles of one environment as JSON
+func apiProfilesHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIProfilesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, _, ok := envWithAccess(w, r, users.UserLevel)
+	if !ok {
+		incMetric(metricAPIProfilesErr)
+		return
+	}
+	profiles, err := envs.ProfilesCtx(r.Context(), env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting profiles", http.StatusInternalServerError, err)
+		incMetric(metricAPIProfilesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned performance profiles for %s", env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, profiles)
+	incMetric(metricAPIProfilesOK)
+}
+
+// POST Handler to create or update a performance profile of one environment
+func apiSetProfileHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIProfilesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, actor, ok := envWithAccess(w, r, users.AdminLevel)
+	if !ok {
+		incMetric(metricAPIProfilesErr)
+		return
+	}
+	var p types.ApiProfileRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPIProfilesErr)
+		return
+	}
+	profile, err := envs.SetProfile(env.ID, environments.PerformanceProfile{
+		Name:           p.Name,
+		Priority:       p.Priority,
+		Tag:            p.Tag,
+		Platform:       p.Platform,
+		HardwareModel:  p.HardwareModel,
+		ConfigInterval: p.ConfigInterval,
+		LogInterval:    p.LogInterval,
+		QueryInterval:  p.QueryInterval,
+	})
+	if err != nil {
+		apiErrorResponse(w, "error saving profile", http.StatusBadRequest, err)
+		incMetric(metricAPIProfilesErr)
+		return
+	}
+	recordActivity(env, environments.ActivityProfiles, actor, "performance profile "+profile.Name+" saved", "", "")
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Saved performance profile %s for %s", profile.Name, env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, profile)
+	incMetric(metricAPIProfilesOK)
+}
+
+// POST Handler to delete a performance profile of one environment
+func apiDeleteProfileHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIProfilesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, actor, ok := envWithAccess(w, r, users.AdminLevel)
+	if !ok {
+		incMetric(metricAPIProfilesErr)
+		return
+	}
+	var p types.ApiProfileRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPIProfilesErr)
+		return
+	}
+	if err := envs.DeleteProfile(env.ID, p.Name); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			apiErrorResponse(w, "profile not found", http.StatusNotFound, err)
+		} else {
+			apiErrorResponse(w, "error deleting profile", http.StatusInternalServerError, err)
+		}
+		incMetric(metricAPIProfilesErr)
+		return
+	}
+	// Return message as serialized response
+	msg := fmt.Sprintf("performance profile %s deleted", p.Name)
+	recordActivity(env, environments.ActivityProfiles, actor, msg, "", "")
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: %s for %s", msg, env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiGenericResponse{Message: msg})
+	incMetric(metricAPIProfilesOK)
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+const (
+	metricAPIPseudonymsReq = "pseudonyms-req"
+	metricAPIPseudonymsErr = "pseudonyms-err"
+	metricAPIPseudonymsOK  = "pseudonyms-ok"
+)
+
+// Helper to get the rows of the results of queries of an environment, pseudonymized when it is enabled
+func envQueryRows(env environments.TLSEnvironment) func(name string, fn func(node string, collected time.Time, row map[string]string) error) error {
+	p := environments.NewPseudonymizer(env)
+	if p == nil {
+		return postgresQueryRows
+	}
+	return func(name string, fn func(node string, collected time.Time, row map[string]string) error) error {
+		return postgresQueryRows(name, func(node string, collected time.Time, row map[string]string) error {
+			return fn(node, collected, p.Row(name, row))
+		})
+	}
+}
+
+// GET Handler to return the columns and tables pseudonymized in the logs sent by an environment
+func apiPseudonymsHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIPseudonymsReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, _, ok := envWithAccess(w, r, users.AdminLevel)
+	if !ok {
+		incMetric(metricAPIPseudonymsErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned pseudonyms for %s", env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, environments.PseudonymsOf(env))
+	incMetric(metricAPIPseudonymsOK)
+}
+
+// POST Handler to set the columns and tables pseudonymized in the logs sent by an environment
+func apiSetPseudonymsHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIPseudonymsReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, actor, ok := envWithAccess(w, r, users.AdminLevel)
+	if !ok {
+		incMetric(metricAPIPseudonymsErr)
+		return
+	}
+	var p types.ApiPseudonymsRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPIPseudonymsErr)
+		return
+	}
+	if err := environments.ValidatePseudonymPatterns(p.Columns + "," + p.Tables); err != nil {
+		apiErrorResponse(w, err.Error(), http.StatusBadRequest, err)
+		incMetric(metricAPIPseudonymsErr)
+		return
+	}
+	if err := envs.UpdatePseudonyms(env.Name, p.Columns, p.Tables); err != nil {
+		apiErrorResponse(w, "error setting pseudonyms", http.StatusInternalServerError, err)
+		incMetric(metricAPIPseudonymsErr)
+		return
+	}
+	updated, err := envs.Get(env.Name)
+	if err != nil {
+		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, err)
+		incMetric(metricAPIPseudonymsErr)
+		return
+	}
+	recordActivity(env, environments.ActivityPseudonyms, actor, "pseudonymized columns and tables updated",
+		environments.ActivityValue(env, environments.ActivityPseudonyms), environments.ActivityValue(updated, environments.ActivityPseudonyms))
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Pseudonyms of %s updated", env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, environments.PseudonymsOf(updated))
+	incMetric(metricAPIPseudonymsOK)
+}
+
+// GET Handler to re-identify a pseudonym found in the logs of an environment, every lookup is recorded
+func apiPseudonymLookupHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIPseudonymsReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, actor, ok := envWithAccess(w, r, users.AdminLevel)
+	if !ok {
+		incMetric(metricAPIPseudonymsErr)
+		return
+	}
+	pseudonym := mux.Vars(r)["pseudonym"]
+	if !strings.HasPrefix(pseudonym, environments.PseudonymPrefix) {
+		apiErrorResponse(w, "invalid pseudonym", http.StatusBadRequest, nil)
+		incMetric(metricAPIPseudonymsErr)
+		return
+	}
+	m, err := envs.LookupPseudonym(env.ID, pseudonym)
+	if err != nil {
+		recordActivity(env, environments.ActivityPseudonyms, actor, fmt.Sprintf("pseudonym %s looked up, not found", pseudonym), "", "")
+		if err.Error() == "record not found" {
+			apiErrorResponse(w, "pseudonym not found", http.StatusNotFound, err)
+		} else {
+			apiErrorResponse(w, "error getting pseudonym", http.StatusInternalServerError, err)
+		}
+		incMetric(metricAPIPseudonymsErr)
+		return
+	}
+	recordActivity(env, environments.ActivityPseudonyms, actor, fmt.Sprintf("pseudonym %s of column %s re-identified", pseudonym, m.Column), "", "")
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Pseudonym of %s re-identified by %s", env.Name, actor)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiPseudonymLookup{Pseudonym: m.Pseudonym, Column: m.Column, Value: m.Value})
+	incMetric(metricAPIPseudonymsOK)
+}
@@ -0,0 +1,891 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/queries"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	metricAPIQueriesReq = "queries-req"
+	metricAPIQueriesErr = "queries-err"
+	metricAPIQueriesOK  = "queries-ok"
+)
+
+// GET Handler to return a single query in JSON
+func apiQueryShowHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIQueriesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	vars := mux.Vars(r)
+	// Extract name
+	name, ok := vars["name"]
+	if !ok {
+		apiErrorResponse(w, "error getting name", http.StatusInternalServerError, nil)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		apiErrorResponse(w, "error with environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Get environment
+	env, err := envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.QueryLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Get query by name
+	query, err := queriesmgr.GetWithLabels(r.Context(), name, env.ID)
+	if err != nil {
+		if err.Error() == "record not found" {
+			apiErrorResponse(w, "query not found", http.StatusNotFound, err)
+		} else {
+			apiErrorResponse(w, "error getting query", http.StatusInternalServerError, err)
+		}
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Restricted queries are locked for who can not see the results
+	if !apiCanView(r.Context(), ctx, query) {
+		query = queries.Locked(query)
+		if r.URL.Query().Get("schema") == "true" {
+			apiErrorResponse(w, "query results are restricted", http.StatusForbidden, fmt.Errorf("attempt to see query %s by user %s", name, ctx[ctxUser]))
+			incMetric(metricAPIQueriesErr)
+			return
+		}
+	}
+	// Paused nodes keep the query pending until they are unpaused
+	if query.Paused, err = queriesmgr.PausedTargets(query.Name, time.Now()); err != nil {
+		log.Printf("error getting paused nodes of %s %v", query.Name, err)
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned query %s", name)
+	}
+	// Query with ?schema=true include the schema inferred from its results
+	if r.URL.Query().Get("schema") == "true" {
+		if archivedResults(w, name, env.ID) {
+			incMetric(metricAPIQueriesErr)
+			return
+		}
+		schema, err := postgresQuerySchema(env, name)
+		if err != nil {
+			apiErrorResponse(w, "error getting results", http.StatusInternalServerError, err)
+			incMetric(metricAPIQueriesErr)
+			return
+		}
+		utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, APIQueryStatus{DistributedQuery: query, Schema: schema})
+		incMetric(metricAPIQueriesOK)
+		return
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, query)
+	incMetric(metricAPIQueriesOK)
+}
+
+// GET Handler to export the results of a query with typed columns, in ?format=csv, json or parquet
+func apiQueryExportHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIQueriesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	vars := mux.Vars(r)
+	// Extract name
+	name, ok := vars["name"]
+	if !ok {
+		apiErrorResponse(w, "error getting name", http.StatusInternalServerError, nil)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		apiErrorResponse(w, "error with environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Get environment
+	env, err := envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.QueryLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = queries.ExportCSV
+	}
+	if !queries.ValidExportFormat(format) {
+		apiErrorResponse(w, "invalid export format", http.StatusBadRequest, fmt.Errorf("format %s", format))
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Get query by name
+	query, err := queriesmgr.Get(name, env.ID)
+	if err != nil {
+		if err.Error() == "record not found" {
+			apiErrorResponse(w, "query not found", http.StatusNotFound, err)
+		} else {
+			apiErrorResponse(w, "error getting query", http.StatusInternalServerError, err)
+		}
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	if !apiCanView(r.Context(), ctx, query) {
+		apiErrorResponse(w, "query results are restricted", http.StatusForbidden, fmt.Errorf("attempt to export query %s by user %s", name, ctx[ctxUser]))
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Archived queries are exported from the archive or once rehydrated
+	if archivedResults(w, name, env.ID) {
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Exports requested as jobs are materialized, so they can be downloaded in ranges and resumed
+	if r.URL.Query().Get("async") == "true" {
+		exportJobResponse(w, env, name, format, ctx[ctxUser], func() (queries.ResultSchema, error) {
+			return postgresQuerySchema(env, name)
+		})
+		return
+	}
+	// Results are read twice, first to infer the schema and then to export the typed rows
+	schema, err := postgresQuerySchema(env, name)
+	if err != nil {
+		apiErrorResponse(w, "error getting results", http.StatusInternalServerError, err)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Large results are always materialized, small ones are streamed
+	if exportsThreshold > 0 && schema.Rows >= exportsThreshold {
+		exportJobResponse(w, env, name, format, ctx[ctxUser], func() (queries.ResultSchema, error) {
+			return schema, nil
+		})
+		return
+	}
+	filename := fmt.Sprintf("%s-%s.%s", name, env.Name, format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Header().Set(utils.ContentType, queries.ExportContentType(format))
+	w.WriteHeader(http.StatusOK)
+	counter := &environments.AccessCounter{ResponseWriter: w}
+	exporter, err := queries.NewResultExporter(counter, format, schema)
+	if err != nil {
+		log.Printf("error exporting results of %s %v", name, err)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	if err := envQueryRows(env)(name, exporter.Write); err != nil {
+		log.Printf("error exporting results of %s %v", name, err)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	if err := exporter.Close(); err != nil {
+		log.Printf("error exporting results of %s %v", name, err)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	recordAccess(r, env.ID, environments.AccessQueryResult, name, "export as "+format, counter.Bytes)
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Exported %d results of %s as %s", schema.Rows, name, format)
+	}
+	incMetric(metricAPIQueriesOK)
+}
+
+// POST Handler to run a query
+func apiQueriesRunHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIQueriesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		apiErrorResponse(w, "error with environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Get environment
+	env, err := envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.QueryLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	var q types.ApiDistributedQueryRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Critical notices of the environment must be confirmed
+	if err := apiUsers.CheckConfirmation(env.UUID, q.ConfirmNotices); err != nil {
+		apiErrorResponse(w, err.Error(), http.StatusConflict, nil)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Launches over many targets need the name of the environment typed, by the policy of its category
+	broad := len(q.Attributes) > 0 || q.EnrolledAfter != "" || q.EnrolledBefore != "" || q.SeenAfter != "" || q.SeenBefore != ""
+	if err := checkLaunch(env, q.UUID, broad, q.ConfirmName); errors.Is(err, environments.ErrConfirmName) {
+		apiErrorResponse(w, err.Error(), http.StatusPreconditionRequired, nil)
+		incMetric(metricAPIQueriesErr)
+		return
+	} else if err != nil {
+		apiErrorResponse(w, "error getting targets", http.StatusInternalServerError, err)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Queries from a template get the SQL from the template and the variables
+	var tpl *queries.QueryTemplate
+	if q.Template != "" {
+		if q.Query != "" {
+			apiErrorResponse(w, "query and template can not be used together", http.StatusBadRequest, nil)
+			incMetric(metricAPIQueriesErr)
+			return
+		}
+		t, err := queriesmgr.GetTemplate(q.Template)
+		if err != nil {
+			if err == gorm.ErrRecordNotFound {
+				apiErrorResponse(w, "template not found", http.StatusNotFound, err)
+			} else {
+				apiErrorResponse(w, "error getting template", http.StatusInternalServerError, err)
+			}
+			incMetric(metricAPIQueriesErr)
+			return
+		}
+		if q.UUID != "" {
+			if n, err := nodesmgr.GetByUUID(q.UUID); err == nil && !t.SupportsPlatform(n.Platform) {
+				apiErrorResponse(w, "template does not support the platform of the node", http.StatusBadRequest, fmt.Errorf("platform %s", n.Platform))
+				incMetric(metricAPIQueriesErr)
+				return
+			}
+		}
+		tpl = &t
+	} else if q.Query == "" {
+		// FIXME check validity of query
+		// Query can not be empty
+		apiErrorResponse(w, "query can not be empty", http.StatusInternalServerError, nil)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Investigation context is checked before the query is created
+	queryContext := queries.QueryContext{
+		Description: q.Description,
+		TicketID:    q.TicketID,
+		TicketURL:   q.TicketURL,
+		Labels:      q.Labels,
+	}
+	if err := queries.ValidateContext(queryContext); err != nil {
+		apiErrorResponse(w, "invalid query context", http.StatusBadRequest, err)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	window, err := queries.ParseTargetWindow(q.EnrolledAfter, q.EnrolledBefore, q.SeenAfter, q.SeenBefore)
+	if err != nil {
+		apiErrorResponse(w, "invalid time window", http.StatusBadRequest, err)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	attrFilters, err := queries.ParseAttributeTargets(q.Attributes)
+	if err != nil {
+		apiErrorResponse(w, "invalid attributes target", http.StatusBadRequest, err)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	if err := queries.ValidateGroup(q.Group); err != nil {
+		apiErrorResponse(w, "invalid query group", http.StatusBadRequest, err)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	expiresAt, err := queryExpiration(env).ExpiresAt(q.Expiration, time.Now())
+	if err != nil {
+		apiErrorResponse(w, "invalid expiration", http.StatusBadRequest, err)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	if err := queries.ValidateVisibility(q.Visibility, q.VisibilityTeam); err != nil {
+		apiErrorResponse(w, "invalid visibility", http.StatusBadRequest, err)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Prepare and create new query
+	queryName := queries.GenQueryName()
+	newQuery := queries.DistributedQuery{
+		Query:          q.Query,
+		Name:           queryName,
+		Creator:        ctx[ctxUser],
+		Expected:       0,
+		Executions:     0,
+		Active:         true,
+		Completed:      false,
+		Deleted:        false,
+		Hidden:         q.Hidden,
+		Type:           queries.StandardQueryType,
+		EnvironmentID:  env.ID,
+		Group:          q.Group,
+		Priority:       q.Priority,
+		ExpiresAt:      expiresAt,
+		Visibility:     q.Visibility,
+		VisibilityTeam: q.VisibilityTeam,
+	}
+	if tpl != nil {
+		if err := queries.ApplyTemplate(&newQuery, *tpl, q.Variables); err != nil {
+			apiErrorResponse(w, "invalid template variables", http.StatusBadRequest, err)
+			incMetric(metricAPIQueriesErr)
+			return
+		}
+	}
+	// Query policy of the creator, admins can override it with a justification
+	overridden := queriesmgr.CheckPolicy(env.ID, ctx[ctxUser], newQuery.Query, tpl)
+	if overridden != nil {
+		if !q.Override {
+			apiErrorResponse(w, overridden.Error(), http.StatusForbidden, nil)
+			incMetric(metricAPIQueriesErr)
+			return
+		}
+		if !checkAccess(ctx, users.AdminLevel, env.UUID) {
+			apiErrorResponse(w, "only admins can override query policies", http.StatusForbidden, overridden)
+			incMetric(metricAPIQueriesErr)
+			return
+		}
+		if strings.TrimSpace(q.Justification) == "" {
+			apiErrorResponse(w, "justification is required to override query policies", http.StatusBadRequest, nil)
+			incMetric(metricAPIQueriesErr)
+			return
+		}
+	}
+	if q.Profile {
+		newQuery.Type = queries.ProfileQueryType
+		newQuery.SampleSize = q.Sample
+		if newQuery.SampleSize <= 0 {
+			newQuery.SampleSize = queries.DefaultProfileSample
+		}
+	}
+	if err := queriesmgr.Create(newQuery); err != nil {
+		apiErrorResponse(w, "error creating query", http.StatusInternalServerError, err)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	if overridden != nil {
+		recordActivity(env, environments.ActivityPolicies, ctx[ctxUser], fmt.Sprintf("query %s run overriding policy (%v): %s", queryName, overridden, q.Justification), "", newQuery.Query)
+	}
+	if !queryContext.Empty() {
+		if _, err := queriesmgr.SetContext(queryName, env.ID, queryContext); err != nil {
+			apiErrorResponse(w, "error setting query context", http.StatusInternalServerError, err)
+			incMetric(metricAPIQueriesErr)
+			return
+		}
+	}
+	// Create UUID target
+	if (q.UUID != "") && nodesmgr.CheckByUUID(q.UUID) {
+		if err := queriesmgr.CreateTarget(queryName, queries.QueryTargetUUID, q.UUID); err != nil {
+			apiErrorResponse(w, "error creating query UUID target", http.StatusInternalServerError, err)
+			incMetric(metricAPICarvesErr)
+			return
+		}
+	}
+	// Create custom attributes targets
+	attrTargets, err := queriesmgr.CreateAttributeTargets(queryName, attrFilters)
+	if err != nil {
+		apiErrorResponse(w, "error creating query attributes target", http.StatusInternalServerError, err)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Create time window targets
+	if err := queriesmgr.CreateWindowTargets(queryName, window); err != nil {
+		apiErrorResponse(w, "error creating query time window targets", http.StatusInternalServerError, err)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Materialize the query for the targeted nodes
+	targeted, err := queriesmgr.Materialize(queryName, env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error materializing query", http.StatusInternalServerError, err)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Nodes within a time window or by attributes are the ones materialized
+	expected := 1
+	if !window.Empty() || attrTargets > 0 {
+		expected = targeted
+	}
+	// Update value for expected
+	if q.Profile {
+		err = queriesmgr.SetProfileTargets(queryName, expected, env.ID)
+	} else {
+		err = queriesmgr.SetExpected(queryName, expected, env.ID)
+	}
+	if err != nil {
+		apiErrorResponse(w, "error setting expected", http.StatusInternalServerError, err)
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	// Return query name as serialized response
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiQueriesResponse{Name: newQuery.Name})
+	incMetric(metricAPIQueriesOK)
+}
+
+// GET Handler to return all queries in JSON
+func apiAllQueriesShowHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIQueriesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		apiErrorResponse(w, "error with environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Get environment
+	env, err := envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.QueryLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Get queries
+	qs, err := apiListQueries(r.Context(), ctx, queries.TargetCompleted, queries.StandardQueryType, env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting queries", http.StatusInternalServerError, err)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Filter by label and ticket, the labels are loaded for correlation
+	qs, err = queriesmgr.FilterContext(r.Context(), qs, queries.ParseContextFilter(r.URL.Query().Get("label"), r.URL.Query().Get("ticket")))
+	if err != nil {
+		apiErrorResponse(w, "error filtering queries", http.StatusInternalServerError, err)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	if len(qs) == 0 {
+		apiErrorResponse(w, "no queries", http.StatusNotFound, nil)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Serialize and serve JSON
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, qs)
+	incMetric(metricAPIQueriesOK)
+}
+
+// GET Handler to return hidden queries in JSON
+func apiHiddenQueriesShowHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIQueriesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		apiErrorResponse(w, "error with environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Get environment
+	env, err := envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.QueryLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Get queries
+	qs, err := apiListQueries(r.Context(), ctx, queries.TargetHiddenCompleted, queries.StandardQueryType, env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting queries", http.StatusInternalServerError, err)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Filter by label and ticket, the labels are loaded for correlation
+	qs, err = queriesmgr.FilterContext(r.Context(), qs, queries.ParseContextFilter(r.URL.Query().Get("label"), r.URL.Query().Get("ticket")))
+	if err != nil {
+		apiErrorResponse(w, "error filtering queries", http.StatusInternalServerError, err)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	if len(qs) == 0 {
+		apiErrorResponse(w, "no queries", http.StatusNotFound, nil)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Serialize and serve JSON
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, qs)
+	incMetric(metricAPIQueriesOK)
+}
+
+// GET Handler to return a single query results in JSON
+func apiQueryResultsHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIQueriesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	vars := mux.Vars(r)
+	// Extract name
+	name, ok := vars["name"]
+	if !ok {
+		apiErrorResponse(w, "error getting name", http.StatusInternalServerError, nil)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		apiErrorResponse(w, "error with environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Get environment
+	env, err := envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.QueryLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Results of restricted queries are only for who can see them
+	if !apiCanViewName(r.Context(), ctx, name, env.ID) {
+		apiErrorResponse(w, "query results are restricted", http.StatusForbidden, fmt.Errorf("attempt to see query %s by user %s", name, ctx[ctxUser]))
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Archived queries have no results until they are rehydrated
+	if archivedResults(w, name, env.ID) {
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Get query by name
+	// TODO retrieve from redis
+	queryLogs, err := postgresQueryLogs(name)
+	if err != nil {
+		if err.Error() == "record not found" {
+			apiErrorResponse(w, "query not found", http.StatusNotFound, err)
+		} else {
+			apiErrorResponse(w, "error getting query", http.StatusInternalServerError, err)
+		}
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Results with ?context=true include the investigation context, so they can be correlated
+	if r.URL.Query().Get("context") == "true" {
+		query, err := queriesmgr.GetWithLabels(r.Context(), name, env.ID)
+		if err != nil {
+			apiErrorResponse(w, "error getting query", http.StatusInternalServerError, err)
+			incMetric(metricAPIQueriesErr)
+			return
+		}
+		utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, APIQueryResults{Name: name, Context: queries.ContextOf(query), Results: queryLogs})
+		incMetric(metricAPIQueriesOK)
+		return
+	}
+	// Serialize and serve JSON
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, queryLogs)
+	incMetric(metricAPIQueriesOK)
+}
+
+// POST Handler to change the investigation context of a query, only for the creator of the query and admins
+func apiQueryContextHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIQueriesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	vars := mux.Vars(r)
+	// Extract name
+	name, ok := vars["name"]
+	if !ok {
+		apiErrorResponse(w, "error getting name", http.StatusInternalServerError, nil)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		apiErrorResponse(w, "error with environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Get environment
+	env, err := envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.QueryLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	query, err := queriesmgr.GetCtx(r.Context(), name, env.ID)
+	if err != nil || query.ID == 0 {
+		apiErrorResponse(w, "query not found", http.StatusNotFound, err)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	if query.Creator != ctx[ctxUser] && !checkAccess(ctx, users.AdminLevel, env.UUID) {
+		apiErrorResponse(w, "only the creator and admins can change the context", http.StatusForbidden, fmt.Errorf("attempt to change query %s by user %s", name, ctx[ctxUser]))
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	var after queries.QueryContext
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&after); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	before, err := queriesmgr.SetContext(name, env.ID, after)
+	if err != nil {
+		apiErrorResponse(w, "error setting query context", http.StatusBadRequest, err)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	recordActivity(env, environments.ActivityQueries, ctx[ctxUser], "context of query "+name+" changed", queries.SerializeContext(before), queries.SerializeContext(after))
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Changed context of query %s", name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiGenericResponse{Message: "query context saved successfully"})
+	incMetric(metricAPIQueriesOK)
+}
+
+// GET Handler to return the report of a profile query in JSON
+func apiQueryProfileHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIQueriesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	vars := mux.Vars(r)
+	// Extract name
+	name, ok := vars["name"]
+	if !ok {
+		apiErrorResponse(w, "error getting name", http.StatusInternalServerError, nil)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		apiErrorResponse(w, "error with environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Get environment
+	env, err := envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.QueryLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	if !apiCanViewName(r.Context(), ctx, name, env.ID) {
+		apiErrorResponse(w, "query results are restricted", http.StatusForbidden, fmt.Errorf("attempt to see profile %s by user %s", name, ctx[ctxUser]))
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	report, err := queriesmgr.GetProfileReport(name, env.ID)
+	if err != nil {
+		if err.Error() == "record not found" {
+			apiErrorResponse(w, "query not found", http.StatusNotFound, err)
+		} else {
+			apiErrorResponse(w, "error getting profile", http.StatusInternalServerError, err)
+		}
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned profile %s", name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, report)
+	incMetric(metricAPIQueriesOK)
+}
+
+// POST Handler to launch the real query from a profile query
+func apiQueryProfileLaunchHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIQueriesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	vars := mux.Vars(r)
+	// Extract name
+	name, ok := vars["name"]
+	if !ok {
+		apiErrorResponse(w, "error getting name", http.StatusInternalServerError, nil)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		apiErrorResponse(w, "error with environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Get environment
+	env, err := envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.QueryLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Only who can see the profile can launch it, the launched query has the same visibility
+	if !apiCanViewName(r.Context(), ctx, name, env.ID) {
+		apiErrorResponse(w, "query results are restricted", http.StatusForbidden, fmt.Errorf("attempt to launch profile %s by user %s", name, ctx[ctxUser]))
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	newQuery, err := queriesmgr.LaunchProfile(name, env.ID, ctx[ctxUser])
+	if err != nil {
+		apiErrorResponse(w, "error launching query", http.StatusInternalServerError, err)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Return query name as serialized response
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiQueriesResponse{Name: newQuery.Name})
+	incMetric(metricAPIQueriesOK)
+}
+
+// GET Handler to get the concurrency groups of queries with their limits and utilization
+func apiQueryGroupsHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIQueriesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		apiErrorResponse(w, "error with environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Get environment
+	env, err := envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.QueryLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	groups, err := queriesmgr.GroupsUtilization(r.Context(), env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting query groups", http.StatusInternalServerError, err)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned %d query groups", len(groups))
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, groups)
+	incMetric(metricAPIQueriesOK)
+}
+
+// POST Handler to set the concurrency limits of a group of queries
+func apiQueryGroupLimitsHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIQueriesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		apiErrorResponse(w, "error with environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Get environment
+	env, err := envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Get context data and check access, limits apply to all the queries of the group
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.AdminLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	var g types.ApiQueryGroupRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&g); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	group, err := queriesmgr.SetGroupLimits(env.ID, g.Name, g.MaxPerNode, g.MaxTotal, ctx[ctxUser])
+	if err != nil {
+		apiErrorResponse(w, "error setting group limits", http.StatusBadRequest, err)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Limits of group %s set to %d per node and %d total", group.Name, group.MaxPerNode, group.MaxTotal)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiGenericResponse{Message: fmt.Sprintf("limits of group %s set", group.Name)})
+	incMetric(metricAPIQueriesOK)
+}
@@ -0,0 +1,296 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/jmpsec/osctrl/queries"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	metricAPIQuickActionsReq = "quickactions-req"
+	metricAPIQuickActionsErr = "quickactions-err"
+	metricAPIQuickActionsOK  = "quickactions-ok"
+)
+
+// Helper to get the permission needed to run each type of quick action
+func quickActionLevel(kind string) users.AccessLevel {
+	switch kind {
+	case queries.QuickActionQuery, queries.QuickActionAccelerate:
+		return users.QueryLevel
+	case queries.QuickActionCarve:
+		return users.CarveLevel
+	}
+	return users.AdminLevel
+}
+
+// Helper to describe a quick action for the activity of the environment
+func quickActionSummary(a queries.QuickAction) string {
+	if a.Name == "" {
+		return ""
+	}
+	return fmt.Sprintf("version: %d\nlabel: %s\ntype: %s\n%s", a.Version, a.Label, a.Type, a.Definition())
+}
+
+// GET Handler to return the quick actions of one environment as JSON
+func apiQuickActionsHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIQuickActionsReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, _, ok := envWithAccess(w, r, users.UserLevel)
+	if !ok {
+		incMetric(metricAPIQuickActionsErr)
+		return
+	}
+	actions, err := queriesmgr.GetQuickActions(env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting quick actions", http.StatusInternalServerError, err)
+		incMetric(metricAPIQuickActionsErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned quick actions for %s", env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, actions)
+	incMetric(metricAPIQuickActionsOK)
+}
+
+// POST Handler to create or update a quick action of one environment
+func apiSetQuickActionHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIQuickActionsReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, actor, ok := envWithAccess(w, r, users.AdminLevel)
+	if !ok {
+		incMetric(metricAPIQuickActionsErr)
+		return
+	}
+	var q types.ApiQuickActionRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPIQuickActionsErr)
+		return
+	}
+	a := queries.QuickAction{
+		Name:     q.Name,
+		Label:    q.Label,
+		Icon:     q.Icon,
+		Type:     q.Type,
+		Template: q.Template,
+		Path:     q.Path,
+		Tag:      q.Tag,
+	}
+	if len(q.Variables) > 0 {
+		raw, _ := json.Marshal(q.Variables)
+		a.Variables = string(raw)
+	}
+	before, _ := queriesmgr.GetQuickAction(q.Name, env.ID)
+	saved, err := queriesmgr.SetQuickAction(env.ID, a, actor)
+	if err != nil {
+		apiErrorResponse(w, "error saving quick action: "+err.Error(), http.StatusBadRequest, err)
+		incMetric(metricAPIQuickActionsErr)
+		return
+	}
+	recordActivity(env, environments.ActivityQuickActions, actor, fmt.Sprintf("quick action %s saved as version %d", saved.Name, saved.Version),
+		quickActionSummary(before), quickActionSummary(saved))
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Saved quick action %s for %s", saved.Name, env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, saved)
+	incMetric(metricAPIQuickActionsOK)
+}
+
+// GET Handler to return all the versions of a quick action of one environment
+func apiQuickActionVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIQuickActionsReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, _, ok := envWithAccess(w, r, users.UserLevel)
+	if !ok {
+		incMetric(metricAPIQuickActionsErr)
+		return
+	}
+	name := mux.Vars(r)["name"]
+	versions, err := queriesmgr.GetQuickActionVersions(name, env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting quick action versions", http.StatusInternalServerError, err)
+		incMetric(metricAPIQuickActionsErr)
+		return
+	}
+	if len(versions) == 0 {
+		apiErrorResponse(w, "quick action not found", http.StatusNotFound, nil)
+		incMetric(metricAPIQuickActionsErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned versions of quick action %s for %s", name, env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, versions)
+	incMetric(metricAPIQuickActionsOK)
+}
+
+// POST Handler to delete a quick action of one environment
+func apiDeleteQuickActionHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIQuickActionsReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, actor, ok := envWithAccess(w, r, users.AdminLevel)
+	if !ok {
+		incMetric(metricAPIQuickActionsErr)
+		return
+	}
+	name := mux.Vars(r)["name"]
+	before, err := queriesmgr.GetQuickAction(name, env.ID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			apiErrorResponse(w, "quick action not found", http.StatusNotFound, err)
+		} else {
+			apiErrorResponse(w, "error getting quick action", http.StatusInternalServerError, err)
+		}
+		incMetric(metricAPIQuickActionsErr)
+		return
+	}
+	if err := queriesmgr.DeleteQuickAction(name, env.ID, actor); err != nil {
+		apiErrorResponse(w, "error deleting quick action", http.StatusInternalServerError, err)
+		incMetric(metricAPIQuickActionsErr)
+		return
+	}
+	// Return message as serialized response
+	msg := fmt.Sprintf("quick action %s deleted", name)
+	recordActivity(env, environments.ActivityQuickActions, actor, msg, quickActionSummary(before), "")
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: %s for %s", msg, env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiGenericResponse{Message: msg})
+	incMetric(metricAPIQuickActionsOK)
+}
+
+// POST Handler to run a quick action against nodes of one environment, or preview what it would run
+func apiRunQuickActionHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIQuickActionsReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, actor, ok := envWithAccess(w, r, users.UserLevel)
+	if !ok {
+		incMetric(metricAPIQuickActionsErr)
+		return
+	}
+	var q types.ApiQuickActionRunRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPIQuickActionsErr)
+		return
+	}
+	a, err := queriesmgr.GetQuickAction(mux.Vars(r)["name"], env.ID)
+	if err != nil {
+		apiErrorResponse(w, "quick action not found", http.StatusNotFound, err)
+		incMetric(metricAPIQuickActionsErr)
+		return
+	}
+	// Each type of quick action needs its own permission
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, quickActionLevel(a.Type), env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to run quick action by user %s", actor))
+		incMetric(metricAPIQuickActionsErr)
+		return
+	}
+	var tpl *queries.QueryTemplate
+	if a.Type == queries.QuickActionQuery {
+		t, err := queriesmgr.GetTemplate(a.Template)
+		if err != nil {
+			apiErrorResponse(w, "template of quick action not found", http.StatusConflict, err)
+			incMetric(metricAPIQuickActionsErr)
+			return
+		}
+		tpl = &t
+	}
+	plan, err := queries.PlanQuickAction(a, tpl, q.UUIDs)
+	if err != nil {
+		apiErrorResponse(w, err.Error(), http.StatusBadRequest, err)
+		incMetric(metricAPIQuickActionsErr)
+		return
+	}
+	// Nodes must belong to the environment
+	targets := make([]nodes.OsqueryNode, 0, len(plan.Targets))
+	for _, u := range plan.Targets {
+		node, err := nodesmgr.GetByUUIDEnv(u, env.ID)
+		if err != nil {
+			apiErrorResponse(w, "node "+u+" not found in "+env.Name, http.StatusBadRequest, err)
+			incMetric(metricAPIQuickActionsErr)
+			return
+		}
+		targets = append(targets, node)
+	}
+	if q.Preview {
+		utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiQuickActionRunResponse{Plan: plan})
+		incMetric(metricAPIQuickActionsOK)
+		return
+	}
+	if q.Version != 0 && q.Version != a.Version {
+		apiErrorResponse(w, "quick action changed since it was previewed", http.StatusConflict, nil)
+		incMetric(metricAPIQuickActionsErr)
+		return
+	}
+	var launched []string
+	switch a.Type {
+	case queries.QuickActionTag:
+		for _, n := range targets {
+			if err := tagsmgr.TagNode(plan.Tag, n, actor, false); err != nil && err.Error() != "node already tagged" {
+				apiErrorResponse(w, "error tagging node", http.StatusInternalServerError, err)
+				incMetric(metricAPIQuickActionsErr)
+				return
+			}
+		}
+	default:
+		// Critical notices of the environment must be confirmed
+		if err := apiUsers.CheckConfirmation(env.UUID, q.ConfirmNotices); err != nil {
+			apiErrorResponse(w, err.Error(), http.StatusConflict, nil)
+			incMetric(metricAPIQuickActionsErr)
+			return
+		}
+		if a.Type == queries.QuickActionCarve {
+			// New carves are blocked while the storage quota of the environment is exceeded
+			quota, err := envs.GetStorageQuota(env.ID)
+			if err != nil {
+				apiErrorResponse(w, "error getting storage quota", http.StatusInternalServerError, err)
+				incMetric(metricAPIQuickActionsErr)
+				return
+			}
+			if quota.Active(environments.QuotaBlockCarves) {
+				apiErrorResponse(w, "storage quota exceeded, new carves are blocked", http.StatusInsufficientStorage, nil)
+				incMetric(metricAPIQuickActionsErr)
+				return
+			}
+		}
+		expiresAt, err := queryExpiration(env).ExpiresAt("", time.Now())
+		if err != nil {
+			apiErrorResponse(w, "invalid expiration", http.StatusBadRequest, err)
+			incMetric(metricAPIQuickActionsErr)
+			return
+		}
+		launched, err = queriesmgr.LaunchQuickAction(plan, env.ID, actor, expiresAt)
+		if err != nil {
+			apiErrorResponse(w, "error running quick action", http.StatusInternalServerError, err)
+			incMetric(metricAPIQuickActionsErr)
+			return
+		}
+	}
+	recordActivity(env, environments.ActivityQuickActions, actor, queries.QuickActionRunSummary(plan, launched), "", plan.Summary)
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Quick action %s run by %s in %s", a.Name, actor, env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiQuickActionRunResponse{Plan: plan, Queries: launched})
+	incMetric(metricAPIQuickActionsOK)
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/queries"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+// Helper to get the storage used by the carves of a case
+func caseFootprint(caseID string) (queries.CaseFootprint, error) {
+	footprint := queries.CaseFootprint{CaseID: caseID}
+	names, err := queriesmgr.CaseQueryNames(caseID)
+	if err != nil {
+		return footprint, err
+	}
+	footprint.Bytes, footprint.Carves, err = filecarves.Footprint(names)
+	return footprint, err
+}
+
+// GET Handler to return the recurring carves of an environment, with the storage of each case
+func apiRecurringCarvesHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPICarvesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, _, ok := envWithAccess(w, r, users.CarveLevel)
+	if !ok {
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	recurring, err := queriesmgr.GetRecurringCarves(env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting recurring carves", http.StatusInternalServerError, err)
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	details := []queries.RecurringCarveDetail{}
+	for _, rc := range recurring {
+		footprint, err := caseFootprint(rc.CaseID)
+		if err != nil {
+			apiErrorResponse(w, "error getting case storage", http.StatusInternalServerError, err)
+			incMetric(metricAPICarvesErr)
+			return
+		}
+		details = append(details, queries.RecurringCarveDetail{Recurring: rc, Footprint: footprint})
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, details)
+	incMetric(metricAPICarvesOK)
+}
+
+// GET Handler to return one recurring carve with the status of each occurrence per node
+func apiRecurringCarveHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPICarvesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, _, ok := envWithAccess(w, r, users.CarveLevel)
+	if !ok {
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	rc, err := queriesmgr.GetRecurringCarve(mux.Vars(r)["name"], env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting recurring carve", http.StatusNotFound, err)
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	runs, err := queriesmgr.RecurringRuns(rc.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting occurrences", http.StatusInternalServerError, err)
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	footprint, err := caseFootprint(rc.CaseID)
+	if err != nil {
+		apiErrorResponse(w, "error getting case storage", http.StatusInternalServerError, err)
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, queries.RecurringCarveDetail{Recurring: rc, Runs: runs, Footprint: footprint})
+	incMetric(metricAPICarvesOK)
+}
+
+// POST Handler to create a carve launched on a recurrence in the same nodes, for a legal hold
+func apiRecurringCarveCreateHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPICarvesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, user, ok := envWithAccess(w, r, users.CarveLevel)
+	if !ok {
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	var c types.ApiRecurringCarveRequest
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	var endAt time.Time
+	if c.EndAt != "" {
+		var err error
+		if endAt, err = time.Parse(time.RFC3339, c.EndAt); err != nil {
+			apiErrorResponse(w, "invalid end date", http.StatusBadRequest, err)
+			incMetric(metricAPICarvesErr)
+			return
+		}
+	}
+	rc, err := queriesmgr.NewRecurringCarve(env.ID, c.CaseID, c.Paths, c.UUIDs, c.Recurrence, endAt, user)
+	if err != nil {
+		apiErrorResponse(w, "error creating recurring carve", http.StatusBadRequest, err)
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	log.Printf("Recurring carve %s for case %s created by %s", rc.Name, rc.CaseID, user)
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiQueriesResponse{Name: rc.Name})
+	incMetric(metricAPICarvesOK)
+}
+
+// POST Handler to pause, resume or stop a recurring carve, the carves already collected are kept
+func apiRecurringCarveActionHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPICarvesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, user, ok := envWithAccess(w, r, users.CarveLevel)
+	if !ok {
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	vars := mux.Vars(r)
+	name, action := vars["name"], vars["action"]
+	var err error
+	switch action {
+	case "pause":
+		err = queriesmgr.PauseRecurringCarve(name, env.ID)
+	case "resume":
+		err = queriesmgr.ResumeRecurringCarve(name, env.ID)
+	case "stop":
+		err = queriesmgr.StopRecurringCarve(name, env.ID, user)
+	default:
+		apiErrorResponse(w, "invalid action", http.StatusBadRequest, fmt.Errorf("invalid action %s", action))
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	if err != nil {
+		apiErrorResponse(w, fmt.Sprintf("error with %s of recurring carve", action), http.StatusBadRequest, err)
+		incMetric(metricAPICarvesErr)
+		return
+	}
+	log.Printf("Recurring carve %s of %s: %s by %s", name, env.Name, action, user)
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiGenericResponse{Message: fmt.Sprintf("recurring carve %s: %s", name, action)})
+	incMetric(metricAPICarvesOK)
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/reports"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+const (
+	metricAPIReportsReq = "reports-req"
+	metricAPIReportsErr = "reports-err"
+	metricAPIReportsOK  = "reports-ok"
+)
+
+// Helper to check access to reports, fleet-wide reports need admin access
+func reportAccess(r *http.Request, username, environment string) (bool, error) {
+	if environment == "" || environment == reports.FleetWide {
+		return apiUsers.CheckPermissions(username, users.AdminLevel, users.NoEnvironment), nil
+	}
+	env, err := envs.GetCtx(r.Context(), environment)
+	if err != nil {
+		return false, err
+	}
+	return apiUsers.CheckPermissions(username, users.UserLevel, env.UUID), nil
+}
+
+// GET Handler for all generated reports as JSON
+func apiReportsHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIReportsReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.AdminLevel, users.NoEnvironment) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPIReportsErr)
+		return
+	}
+	// Get reports
+	reportsAll, err := reportsmgr.Gets(0)
+	if err != nil {
+		apiErrorResponse(w, "error getting reports", http.StatusInternalServerError, err)
+		incMetric(metricAPIReportsErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Println("DebugService: Returned reports")
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, reportsAll)
+	incMetric(metricAPIReportsOK)
+}
+
+// POST Handler to generate a report for one environment, or all of them
+func apiReportGenerateHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIReportsReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	var rr types.ApiReportRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&rr); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPIReportsErr)
+		return
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	access, err := reportAccess(r, ctx[ctxUser], rr.Environment)
+	if err != nil {
+		if err.Error() == "record not found" {
+			apiErrorResponse(w, "environment not found", http.StatusNotFound, err)
+		} else {
+			apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, err)
+		}
+		incMetric(metricAPIReportsErr)
+		return
+	}
+	if !access {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPIReportsErr)
+		return
+	}
+	if !environments.IsCategory(rr.Category) {
+		apiErrorResponse(w, "invalid category", http.StatusBadRequest, fmt.Errorf("category %s", rr.Category))
+		incMetric(metricAPIReportsErr)
+		return
+	}
+	req := reports.ReportRequest{
+		Category:      rr.Category,
+		Period:        rr.Period,
+		Format:        rr.Format,
+		Creator:       ctx[ctxUser],
+		InactiveHours: settingsmgr.InactiveHours(),
+	}
+	if rr.Environment != "" && rr.Environment != reports.FleetWide {
+		env, err := envs.GetCtx(r.Context(), rr.Environment)
+		if err != nil {
+			apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, err)
+			incMetric(metricAPIReportsErr)
+			return
+		}
+		req.EnvironmentID = env.ID
+		req.Environment = env.Name
+		req.Locale = env.Locale
+	}
+	report, err := reportsmgr.Generate(r.Context(), req)
+	if err != nil {
+		apiErrorResponse(w, "error generating report", http.StatusBadRequest, err)
+		incMetric(metricAPIReportsErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Generated report %s", report.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, report)
+	incMetric(metricAPIReportsOK)
+}
+
+// GET Handler to download the content of one report
+func apiReportDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIReportsReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	vars := mux.Vars(r)
+	// Extract report id
+	idVar, ok := vars["id"]
+	if !ok {
+		apiErrorResponse(w, "error getting report", http.StatusInternalServerError, nil)
+		incMetric(metricAPIReportsErr)
+		return
+	}
+	id, err := strconv.ParseUint(idVar, 10, 64)
+	if err != nil {
+		apiErrorResponse(w, "invalid report", http.StatusBadRequest, err)
+		incMetric(metricAPIReportsErr)
+		return
+	}
+	report, err := reportsmgr.Get(uint(id))
+	if err != nil {
+		if err.Error() == "record not found" {
+			apiErrorResponse(w, "report not found", http.StatusNotFound, err)
+		} else {
+			apiErrorResponse(w, "error getting report", http.StatusInternalServerError, err)
+		}
+		incMetric(metricAPIReportsErr)
+		return
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	access, err := reportAccess(r, ctx[ctxUser], report.Environment)
+	if err != nil || !access {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPIReportsErr)
+		return
+	}
+	content, err := reportsmgr.Open(r.Context(), report)
+	if err != nil {
+		apiErrorResponse(w, "error reading report", http.StatusInternalServerError, err)
+		incMetric(metricAPIReportsErr)
+		return
+	}
+	defer content.Close()
+	w.Header().Set("Content-Type", reports.ContentType(report.Format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", report.Name))
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, content); err != nil {
+		log.Printf("error sending report %v", err)
+		incMetric(metricAPIReportsErr)
+		return
+	}
+	incMetric(metricAPIReportsOK)
+}
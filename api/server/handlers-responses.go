@@ -0,0 +1,442 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/responses"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	metricAPIResponsesReq = "responses-req"
+	metricAPIResponsesErr = "responses-err"
+	metricAPIResponsesOK  = "responses-ok"
+)
+
+// Default number of recorded response actions returned
+const defaultResponseActions = 100
+
+// GET Handler to return the recorded response actions of one environment as JSON
+func apiResponseActionsHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIResponsesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, _, ok := envWithAccess(w, r, users.UserLevel)
+	if !ok {
+		incMetric(metricAPIResponsesErr)
+		return
+	}
+	limit := defaultResponseActions
+	if l := r.URL.Query().Get("limit"); l != "" {
+		v, err := strconv.Atoi(l)
+		if err != nil || v < 0 {
+			apiErrorResponse(w, "invalid limit", http.StatusBadRequest, err)
+			incMetric(metricAPIResponsesErr)
+			return
+		}
+		limit = v
+	}
+	actions, err := responsesmgr.Actions(env.ID, limit)
+	if err != nil {
+		apiErrorResponse(w, "error getting response actions", http.StatusInternalServerError, err)
+		incMetric(metricAPIResponsesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned response actions for %s", env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, actions)
+	incMetric(metricAPIResponsesOK)
+}
+
+// GET Handler to return the response actions configuration and triggers of one environment as JSON
+func apiResponseConfigHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIResponsesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, _, ok := envWithAccess(w, r, users.UserLevel)
+	if !ok {
+		incMetric(metricAPIResponsesErr)
+		return
+	}
+	current, err := envResponses(env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting response actions configuration", http.StatusInternalServerError, err)
+		incMetric(metricAPIResponsesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned response actions configuration for %s", env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, current)
+	incMetric(metricAPIResponsesOK)
+}
+
+// POST Handler to save the response actions configuration of one environment, or close its circuit breaker
+func apiSetResponseConfigHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIResponsesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, actor, ok := envWithAccess(w, r, users.AdminLevel)
+	if !ok {
+		incMetric(metricAPIResponsesErr)
+		return
+	}
+	var c types.ApiResponseConfigRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPIResponsesErr)
+		return
+	}
+	before, err := responsesmgr.Config(env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting response actions configuration", http.StatusInternalServerError, err)
+		incMetric(metricAPIResponsesErr)
+		return
+	}
+	msg := "response actions configuration saved"
+	if c.Reset {
+		if err := responsesmgr.ResetCircuit(env.ID); err != nil {
+			apiErrorResponse(w, "error resetting circuit breaker", http.StatusInternalServerError, err)
+			incMetric(metricAPIResponsesErr)
+			return
+		}
+		msg = "response actions circuit breaker reset"
+	} else {
+		_, err := responsesmgr.SetConfig(env.ID, responses.ResponseConfig{
+			Enabled:    c.Enabled,
+			Allowed:    c.Allowed,
+			Cooldown:   c.Cooldown,
+			MaxPerHour: c.MaxPerHour,
+		})
+		if err != nil {
+			apiErrorResponse(w, "error saving response actions configuration", http.StatusBadRequest, err)
+			incMetric(metricAPIResponsesErr)
+			return
+		}
+	}
+	current, err := envResponses(env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting response actions configuration", http.StatusInternalServerError, err)
+		incMetric(metricAPIResponsesErr)
+		return
+	}
+	recordActivity(env, environments.ActivityResponses, actor, msg, responses.SerializeConfig(before), responses.SerializeConfig(current.Config))
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: %s for %s", msg, env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, current)
+	incMetric(metricAPIResponsesOK)
+}
+
+// POST Handler to create or update a response trigger of one environment
+func apiSetResponseTriggerHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIResponsesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, actor, ok := envWithAccess(w, r, users.AdminLevel)
+	if !ok {
+		incMetric(metricAPIResponsesErr)
+		return
+	}
+	var c types.ApiResponseTriggerRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPIResponsesErr)
+		return
+	}
+	before, err := responsesmgr.Triggers(env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting response triggers", http.StatusInternalServerError, err)
+		incMetric(metricAPIResponsesErr)
+		return
+	}
+	trigger, err := responsesmgr.SetTrigger(env.ID, responses.ResponseTrigger{
+		Name:      c.Name,
+		Query:     c.Query,
+		Column:    c.Column,
+		Value:     c.Value,
+		Kind:      c.Kind,
+		Template:  c.Template,
+		Variables: responses.EncodeVariables(c.Variables),
+		Path:      c.Path,
+		Enabled:   c.Enabled,
+		Creator:   actor,
+	})
+	if err != nil {
+		apiErrorResponse(w, "error saving response trigger", http.StatusBadRequest, err)
+		incMetric(metricAPIResponsesErr)
+		return
+	}
+	after, err := responsesmgr.Triggers(env.ID)
+	if err != nil {
+		log.Printf("error getting response triggers %v", err)
+	}
+	recordActivity(env, environments.ActivityResponses, actor, "response trigger "+trigger.Name+" saved", responses.SerializeTriggers(before), responses.SerializeTriggers(after))
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Saved response trigger %s for %s", trigger.Name, env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, trigger)
+	incMetric(metricAPIResponsesOK)
+}
+
+// POST Handler to delete a response trigger of one environment
+func apiDeleteResponseTriggerHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIResponsesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, actor, ok := envWithAccess(w, r, users.AdminLevel)
+	if !ok {
+		incMetric(metricAPIResponsesErr)
+		return
+	}
+	var c types.ApiResponseTriggerRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPIResponsesErr)
+		return
+	}
+	before, err := responsesmgr.Triggers(env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting response triggers", http.StatusInternalServerError, err)
+		incMetric(metricAPIResponsesErr)
+		return
+	}
+	if err := responsesmgr.DeleteTrigger(env.ID, c.Name); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			apiErrorResponse(w, "response trigger not found", http.StatusNotFound, err)
+		} else {
+			apiErrorResponse(w, "error deleting response trigger", http.StatusInternalServerError, err)
+		}
+		incMetric(metricAPIResponsesErr)
+		return
+	}
+	after, err := responsesmgr.Triggers(env.ID)
+	if err != nil {
+		log.Printf("error getting response triggers %v", err)
+	}
+	// Return message as serialized response
+	msg := fmt.Sprintf("response trigger %s deleted", c.Name)
+	recordActivity(env, environments.ActivityResponses, actor, msg, responses.SerializeTriggers(before), responses.SerializeTriggers(after))
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: %s for %s", msg, env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiGenericResponse{Message: msg})
+	incMetric(metricAPIResponsesOK)
+}
+
+// Helper to get the permission needed to fire each kind of response action
+func responseActionLevel(kind string) users.AccessLevel {
+	if kind == responses.KindCarve {
+		return users.CarveLevel
+	}
+	return users.QueryLevel
+}
+
+// POST Handler to receive an external alert that fires a response trigger for one node
+func apiResponseAlertHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIResponsesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, _, ok := envWithAccess(w, r, users.QueryLevel)
+	if !ok {
+		incMetric(metricAPIResponsesErr)
+		return
+	}
+	var a types.ApiResponseAlertRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPIResponsesErr)
+		return
+	}
+	cfg, err := responsesmgr.Config(env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting response actions configuration", http.StatusInternalServerError, err)
+		incMetric(metricAPIResponsesErr)
+		return
+	}
+	if !cfg.Enabled {
+		apiErrorResponse(w, "response actions are disabled", http.StatusBadRequest, nil)
+		incMetric(metricAPIResponsesErr)
+		return
+	}
+	trigger, err := responsesmgr.GetTrigger(env.ID, a.Trigger)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			apiErrorResponse(w, "response trigger not found", http.StatusNotFound, err)
+		} else {
+			apiErrorResponse(w, "error getting response trigger", http.StatusInternalServerError, err)
+		}
+		incMetric(metricAPIResponsesErr)
+		return
+	}
+	if !trigger.Enabled {
+		apiErrorResponse(w, "response trigger is disabled", http.StatusBadRequest, nil)
+		incMetric(metricAPIResponsesErr)
+		return
+	}
+	// Carves need carve access on top of query access
+	if level := responseActionLevel(trigger.Kind); level != users.QueryLevel {
+		ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+		if !checkAccess(ctx, level, env.UUID) {
+			apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to carve with alert by user %s", ctx[ctxUser]))
+			incMetric(metricAPIResponsesErr)
+			return
+		}
+	}
+	node, err := nodesmgr.GetByIdentifierCtx(r.Context(), a.UUID)
+	if err != nil || node.EnvironmentID != env.ID {
+		apiErrorResponse(w, "node not found", http.StatusNotFound, err)
+		incMetric(metricAPIResponsesErr)
+		return
+	}
+	action, err := responsesmgr.Fire(trigger, node, a.Event, responses.SourceAlert)
+	if err != nil {
+		apiErrorResponse(w, "error firing response trigger", http.StatusInternalServerError, err)
+		incMetric(metricAPIResponsesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Response trigger %s fired for %s with status %s", trigger.Name, node.UUID, action.Status)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, action)
+	incMetric(metricAPIResponsesOK)
+}
+
+// Helper to get the response actions configuration and triggers of one environment
+func envResponses(envid uint) (responses.EnvResponses, error) {
+	cfg, err := responsesmgr.Config(envid)
+	if err != nil {
+		return responses.EnvResponses{}, err
+	}
+	triggers, err := responsesmgr.Triggers(envid)
+	if err != nil {
+		return responses.EnvResponses{}, err
+	}
+	return responses.EnvResponses{Config: cfg, Triggers: triggers}, nil
+}
+
+// GET Handler to return the mutes of one environment as JSON
+func apiMutesHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIResponsesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, _, ok := envWithAccess(w, r, users.UserLevel)
+	if !ok {
+		incMetric(metricAPIResponsesErr)
+		return
+	}
+	mutes, err := responsesmgr.Mutes(env.ID, time.Now())
+	if err != nil {
+		apiErrorResponse(w, "error getting mutes", http.StatusInternalServerError, err)
+		incMetric(metricAPIResponsesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned mutes for %s", env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, mutes)
+	incMetric(metricAPIResponsesOK)
+}
+
+// POST Handler to mute notifications for a node or for one environment, permanent mutes need admin access
+func apiSetMuteHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIResponsesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, actor, ok := envWithAccess(w, r, users.QueryLevel)
+	if !ok {
+		incMetric(metricAPIResponsesErr)
+		return
+	}
+	var m types.ApiMuteRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPIResponsesErr)
+		return
+	}
+	if m.Permanent {
+		ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+		if !checkAccess(ctx, users.AdminLevel, env.UUID) {
+			apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to set permanent mute by user %s", ctx[ctxUser]))
+			incMetric(metricAPIResponsesErr)
+			return
+		}
+	}
+	now := time.Now()
+	mute := responses.Mute{
+		EnvironmentID: env.ID,
+		NodeUUID:      m.UUID,
+		Kind:          m.Kind,
+		Rule:          m.Rule,
+		Reason:        m.Reason,
+		Creator:       actor,
+		Permanent:     m.Permanent,
+		NotifyExpiry:  m.Notify && !m.Permanent,
+	}
+	if !m.Permanent {
+		mute.ExpiresAt = now.Add(time.Duration(m.Hours) * time.Hour)
+	}
+	saved, err := responsesmgr.SetMute(mute, now)
+	if err != nil {
+		apiErrorResponse(w, "error saving mute", http.StatusBadRequest, err)
+		incMetric(metricAPIResponsesErr)
+		return
+	}
+	recordActivity(env, environments.ActivityMutes, actor, "muted "+saved.Scope()+": "+saved.Reason, "", "")
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Muted %s in %s", saved.Scope(), env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, saved)
+	incMetric(metricAPIResponsesOK)
+}
+
+// POST Handler to lift a mute of one environment before it expires
+func apiDeleteMuteHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIResponsesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, actor, ok := envWithAccess(w, r, users.QueryLevel)
+	if !ok {
+		incMetric(metricAPIResponsesErr)
+		return
+	}
+	var m types.ApiMuteRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPIResponsesErr)
+		return
+	}
+	lifted, err := responsesmgr.DeleteMute(env.ID, m.ID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			apiErrorResponse(w, "mute not found", http.StatusNotFound, err)
+		} else {
+			apiErrorResponse(w, "error deleting mute", http.StatusInternalServerError, err)
+		}
+		incMetric(metricAPIResponsesErr)
+		return
+	}
+	// Return message as serialized response
+	msg := "unmuted " + lifted.Scope()
+	recordActivity(env, environments.ActivityMutes, actor, msg, "", "")
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: %s in %s", msg, env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiGenericResponse{Message: msg})
+	incMetric(metricAPIResponsesOK)
+}
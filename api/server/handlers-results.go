@@ -0,0 +1,283 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/logging"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+const (
+	metricAPIResultsReq = "results-req"
+	metricAPIResultsErr = "results-err"
+	metricAPIResultsOK  = "results-ok"
+)
+
+const (
+	// Default time back for results, when since is not provided
+	defaultResultsSince = "6h"
+	// Default and maximum number of results returned at once
+	defaultResultsLimit = 1000
+	maxResultsLimit     = 10000
+	// Interval to check for new results when streaming
+	resultsStreamInterval = 5 * time.Second
+	// Content type of streamed and exported results, one JSON per line
+	resultsStreamType = "application/x-ndjson"
+	// Formats to export results
+	resultsExportCSV    = "csv"
+	resultsExportNDJSON = "ndjson"
+)
+
+// Helper to get the environment of a results request and check access
+func resultsEnv(w http.ResponseWriter, r *http.Request) (environments.TLSEnvironment, bool) {
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
+		return environments.TLSEnvironment{}, false
+	}
+	// Get environment by name
+	env, err := envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		if err.Error() == "record not found" {
+			apiErrorResponse(w, "environment not found", http.StatusNotFound, err)
+		} else {
+			apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, err)
+		}
+		return env, false
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.UserLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		return env, false
+	}
+	return env, true
+}
+
+// Helper to check if an action of results is valid
+func validResultAction(action string) bool {
+	for _, a := range types.ResultActions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper to get the filter of results from query parameters: node, name, action, since, after and limit
+func resultsFilter(r *http.Request, env environments.TLSEnvironment, since string) (logging.ResultFilter, error) {
+	q := r.URL.Query()
+	filter := logging.ResultFilter{
+		Environment: env.Name,
+		UUID:        q.Get("node"),
+		Name:        q.Get("name"),
+		Action:      q.Get("action"),
+		Limit:       defaultResultsLimit,
+	}
+	if filter.Action != "" && !validResultAction(filter.Action) {
+		return filter, fmt.Errorf("invalid action %s", filter.Action)
+	}
+	if v := q.Get("since"); v != "" {
+		since = v
+	}
+	var err error
+	if filter.Since, err = environments.ParseSince(since, time.Now()); err != nil {
+		return filter, err
+	}
+	if v := q.Get("after"); v != "" {
+		after, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return filter, fmt.Errorf("invalid after %s", v)
+		}
+		filter.AfterID = uint(after)
+	}
+	if v := q.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil || filter.Limit <= 0 {
+			return filter, fmt.Errorf("invalid limit %s", v)
+		}
+		if filter.Limit > maxResultsLimit {
+			filter.Limit = maxResultsLimit
+		}
+	}
+	return filter, nil
+}
+
+// GET Handler to return results of scheduled queries of one environment as JSON events
+func apiResultsHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIResultsReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, ok := resultsEnv(w, r)
+	if !ok {
+		incMetric(metricAPIResultsErr)
+		return
+	}
+	filter, err := resultsFilter(r, env, defaultResultsSince)
+	if err != nil {
+		apiErrorResponse(w, "invalid results filter", http.StatusBadRequest, err)
+		incMetric(metricAPIResultsErr)
+		return
+	}
+	logs, err := postgresResultLogs(filter)
+	if err != nil {
+		apiErrorResponse(w, "error getting results", http.StatusInternalServerError, err)
+		incMetric(metricAPIResultsErr)
+		return
+	}
+	events := resultEvents(logs)
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned %d results for %s", len(events), env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, events)
+	incMetric(metricAPIResultsOK)
+}
+
+// GET Handler to stream new results of scheduled queries of one environment, one JSON event per line
+func apiResultsStreamHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIResultsReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, ok := resultsEnv(w, r)
+	if !ok {
+		incMetric(metricAPIResultsErr)
+		return
+	}
+	// Only new results are streamed, unless since or after are provided
+	filter, err := resultsFilter(r, env, "0s")
+	if err != nil {
+		apiErrorResponse(w, "invalid results filter", http.StatusBadRequest, err)
+		incMetric(metricAPIResultsErr)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		apiErrorResponse(w, "streaming not supported", http.StatusInternalServerError, nil)
+		incMetric(metricAPIResultsErr)
+		return
+	}
+	w.Header().Set(utils.ContentType, resultsStreamType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	incMetric(metricAPIResultsOK)
+	ticker := time.NewTicker(resultsStreamInterval)
+	defer ticker.Stop()
+	enc := json.NewEncoder(w)
+	for {
+		logs, err := postgresResultLogs(filter)
+		if err != nil {
+			log.Printf("error streaming results for %s - %v", env.Name, err)
+			return
+		}
+		for _, e := range resultEvents(logs) {
+			if err := enc.Encode(e); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+		if len(logs) > 0 {
+			filter.AfterID = logs[len(logs)-1].ID
+		}
+		// Keep reading while there are more results than the limit
+		if len(logs) == filter.Limit {
+			continue
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// GET Handler to export results of scheduled queries of one environment as CSV or one JSON event per line
+func apiResultsExportHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIResultsReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, ok := resultsEnv(w, r)
+	if !ok {
+		incMetric(metricAPIResultsErr)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = resultsExportCSV
+	}
+	if format != resultsExportCSV && format != resultsExportNDJSON {
+		apiErrorResponse(w, "invalid export format", http.StatusBadRequest, fmt.Errorf("format %s", format))
+		incMetric(metricAPIResultsErr)
+		return
+	}
+	filter, err := resultsFilter(r, env, defaultResultsSince)
+	if err != nil {
+		apiErrorResponse(w, "invalid results filter", http.StatusBadRequest, err)
+		incMetric(metricAPIResultsErr)
+		return
+	}
+	logs, err := postgresResultLogs(filter)
+	if err != nil {
+		apiErrorResponse(w, "error getting results", http.StatusInternalServerError, err)
+		incMetric(metricAPIResultsErr)
+		return
+	}
+	events := resultEvents(logs)
+	filename := fmt.Sprintf("results-%s-%s.%s", env.Name, time.Now().UTC().Format("20060102-150405"), format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	counter := &environments.AccessCounter{ResponseWriter: w}
+	if format == resultsExportNDJSON {
+		w.Header().Set(utils.ContentType, resultsStreamType)
+		w.WriteHeader(http.StatusOK)
+		enc := json.NewEncoder(counter)
+		for _, e := range events {
+			if err := enc.Encode(e); err != nil {
+				log.Printf("error exporting results %v", err)
+				break
+			}
+		}
+	} else {
+		w.Header().Set(utils.ContentType, "text/csv")
+		w.WriteHeader(http.StatusOK)
+		cw := csv.NewWriter(counter)
+		_ = cw.Write([]string{"id", "time", "node", "name", "format", "event_type", "action", "epoch", "counter", "columns"})
+		for _, e := range events {
+			columns, _ := json.Marshal(e.Columns)
+			_ = cw.Write([]string{
+				strconv.FormatUint(uint64(e.ID), 10),
+				e.Time.Format(time.RFC3339),
+				e.Node,
+				e.Name,
+				e.Format,
+				e.EventType,
+				e.Action,
+				strconv.FormatInt(e.Epoch, 10),
+				strconv.FormatInt(e.Counter, 10),
+				string(columns),
+			})
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			log.Printf("error exporting results %v", err)
+		}
+	}
+	entity := filter.Name
+	if entity == "" {
+		entity = "results"
+	}
+	recordAccess(r, env.ID, environments.AccessQueryResult, entity, "export as "+format, counter.Bytes)
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Exported %d results for %s", len(events), env.Name)
+	}
+	incMetric(metricAPIResultsOK)
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+const (
+	metricAPIScheduledReq = "scheduled-req"
+	metricAPIScheduledErr = "scheduled-err"
+	metricAPIScheduledOK  = "scheduled-ok"
+)
+
+// Latest scheduled changes returned for an environment
+const apiScheduledLimit = 20
+
+// GET Handler to return the latest scheduled changes of the configuration of one environment, newest first
+func apiScheduledChangesHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIScheduledReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, _, ok := envWithAccess(w, r, users.AdminLevel)
+	if !ok {
+		incMetric(metricAPIScheduledErr)
+		return
+	}
+	changes, err := envs.ScheduledChanges(env.ID, apiScheduledLimit)
+	if err != nil {
+		apiErrorResponse(w, "error getting scheduled changes", http.StatusInternalServerError, err)
+		incMetric(metricAPIScheduledErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned %d scheduled changes for %s", len(changes), env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, changes)
+	incMetric(metricAPIScheduledOK)
+}
+
+// POST Handler to schedule a change of one section of the configuration of one environment
+func apiScheduleConfigHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIScheduledReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, actor, ok := envWithAccess(w, r, users.AdminLevel)
+	if !ok {
+		incMetric(metricAPIScheduledErr)
+		return
+	}
+	var s types.ApiScheduleConfigRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusBadRequest, err)
+		incMetric(metricAPIScheduledErr)
+		return
+	}
+	// Changes need a note when the policy of the category of the environment asks for it
+	if err := categoryPolicy(env).CheckNote(s.Note); err != nil {
+		apiErrorResponse(w, err.Error(), http.StatusBadRequest, nil)
+		incMetric(metricAPIScheduledErr)
+		return
+	}
+	change, err := envs.ScheduleConfigChange(env, s.Section, s.Value, s.ApplyAt, actor, s.Note, s.Replace, time.Now())
+	if errors.Is(err, environments.ErrPendingChange) {
+		apiErrorResponse(w, "a change is already scheduled, replace it or cancel it first", http.StatusConflict, nil)
+		incMetric(metricAPIScheduledErr)
+		return
+	}
+	if err != nil {
+		apiErrorResponse(w, "error scheduling change", http.StatusBadRequest, err)
+		incMetric(metricAPIScheduledErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Scheduled %s change of %s for %s", change.Section, env.Name, change.ApplyAt)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, change)
+	incMetric(metricAPIScheduledOK)
+}
+
+// POST Handler to cancel the pending scheduled change of the configuration of one environment
+func apiCancelScheduledHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIScheduledReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, actor, ok := envWithAccess(w, r, users.AdminLevel)
+	if !ok {
+		incMetric(metricAPIScheduledErr)
+		return
+	}
+	change, err := envs.CancelConfigChange(env.ID, actor, time.Now())
+	if errors.Is(err, environments.ErrNoPendingChange) {
+		apiErrorResponse(w, "no scheduled change to cancel", http.StatusNotFound, nil)
+		incMetric(metricAPIScheduledErr)
+		return
+	}
+	if err != nil {
+		apiErrorResponse(w, "error cancelling scheduled change", http.StatusInternalServerError, err)
+		incMetric(metricAPIScheduledErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Cancelled scheduled change of %s", env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, change)
+	incMetric(metricAPIScheduledOK)
+}
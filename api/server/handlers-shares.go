@@ -0,0 +1,229 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/jmpsec/osctrl/queries"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+const (
+	metricAPISharesReq = "shares-req"
+	metricAPISharesErr = "shares-err"
+	metricAPISharesOK  = "shares-ok"
+)
+
+// Helper to get the environment of a sharing export request, users must be able to run queries in it
+func sharesEnv(w http.ResponseWriter, r *http.Request) (environments.TLSEnvironment, contextValue, bool) {
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	envVar, ok := mux.Vars(r)["env"]
+	if !ok {
+		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
+		return environments.TLSEnvironment{}, ctx, false
+	}
+	env, err := envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		if err.Error() == "record not found" {
+			apiErrorResponse(w, "environment not found", http.StatusNotFound, err)
+		} else {
+			apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, err)
+		}
+		return env, ctx, false
+	}
+	if !checkAccess(ctx, users.QueryLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		return env, ctx, false
+	}
+	return env, ctx, true
+}
+
+// Helper to get a sharing export of the environment in the request by name
+func shareFromRequest(w http.ResponseWriter, r *http.Request, env environments.TLSEnvironment) (queries.ShareExport, bool) {
+	share, err := queriesmgr.GetShare(mux.Vars(r)["name"], env.ID)
+	if err != nil {
+		if err.Error() == "record not found" {
+			apiErrorResponse(w, "sharing export not found", http.StatusNotFound, err)
+		} else {
+			apiErrorResponse(w, "error getting sharing export", http.StatusInternalServerError, err)
+		}
+		return share, false
+	}
+	return share, true
+}
+
+// Helper to generate a sharing export from the results in the DB, the outcome is recorded in the activity of the environment
+func generateShare(env environments.TLSEnvironment, share queries.ShareExport) {
+	src := queries.ShareSource{
+		Query: func(name string) (queries.DistributedQuery, error) {
+			query, err := queriesmgr.Get(name, env.ID)
+			if err == nil && query.ID == 0 {
+				err = fmt.Errorf("record not found")
+			}
+			return query, err
+		},
+		Rows: envQueryRows(env),
+		Node: func(uuid string) (nodes.OsqueryNode, error) {
+			return nodesmgr.GetByUUIDEnv(uuid, env.ID)
+		},
+	}
+	manifest, err := queriesmgr.GenerateShare(context.Background(), share, src)
+	if err != nil {
+		log.Printf("error generating sharing export %s %v", share.Name, err)
+		recordActivity(env, environments.ActivityShares, share.Creator, share.Summary()+" failed", "", err.Error())
+		return
+	}
+	scope, err := json.Marshal(manifest)
+	if err != nil {
+		log.Printf("error serializing manifest of %s %v", share.Name, err)
+	}
+	recordActivity(env, environments.ActivityShares, share.Creator, share.Summary()+" generated", "", string(scope))
+}
+
+// GET Handler to return the sharing exports of an environment
+func apiSharesHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPISharesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, _, ok := sharesEnv(w, r)
+	if !ok {
+		incMetric(metricAPISharesErr)
+		return
+	}
+	shares, err := queriesmgr.Shares(env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting sharing exports", http.StatusInternalServerError, err)
+		incMetric(metricAPISharesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned %d sharing exports for %s", len(shares), env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, shares)
+	incMetric(metricAPISharesOK)
+}
+
+// POST Handler to request a sharing export of queries, it is generated asynchronously
+func apiShareCreateHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPISharesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, ctx, ok := sharesEnv(w, r)
+	if !ok {
+		incMetric(metricAPISharesErr)
+		return
+	}
+	var s types.ApiShareRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPISharesErr)
+		return
+	}
+	// Only queries of the environment can be shared
+	for _, name := range s.Queries {
+		query, err := queriesmgr.GetCtx(r.Context(), name, env.ID)
+		if err != nil || query.ID == 0 {
+			apiErrorResponse(w, "query not found", http.StatusNotFound, fmt.Errorf("query %s - %v", name, err))
+			incMetric(metricAPISharesErr)
+			return
+		}
+		// Results of restricted queries are only shared by who can see them
+		if !apiCanView(r.Context(), ctx, query) {
+			apiErrorResponse(w, "query results are restricted", http.StatusForbidden, fmt.Errorf("attempt to share query %s by user %s", name, ctx[ctxUser]))
+			incMetric(metricAPISharesErr)
+			return
+		}
+	}
+	req := queries.ShareRequest{
+		EnvironmentID: env.ID,
+		Environment:   env.Name,
+		Queries:       s.Queries,
+		Format:        s.Format,
+		Redaction:     queries.ShareRedaction{DropIPs: s.DropIPs, DenyColumns: s.DenyColumns},
+		Creator:       ctx[ctxUser],
+	}
+	share, err := queriesmgr.NewShare(&req)
+	if err != nil {
+		apiErrorResponse(w, "error creating sharing export", http.StatusBadRequest, err)
+		incMetric(metricAPISharesErr)
+		return
+	}
+	go generateShare(env, share)
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Requested sharing export %s", share.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusAccepted, share)
+	incMetric(metricAPISharesOK)
+}
+
+// GET Handler to return the status of a sharing export
+func apiShareHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPISharesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, _, ok := sharesEnv(w, r)
+	if !ok {
+		incMetric(metricAPISharesErr)
+		return
+	}
+	share, ok := shareFromRequest(w, r, env)
+	if !ok {
+		incMetric(metricAPISharesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned sharing export %s", share.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, share)
+	incMetric(metricAPISharesOK)
+}
+
+// GET Handler to download a sharing export once it is ready
+func apiShareDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPISharesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, ctx, ok := sharesEnv(w, r)
+	if !ok {
+		incMetric(metricAPISharesErr)
+		return
+	}
+	share, ok := shareFromRequest(w, r, env)
+	if !ok {
+		incMetric(metricAPISharesErr)
+		return
+	}
+	for _, name := range share.QueryNames() {
+		if !apiCanViewName(r.Context(), ctx, name, env.ID) {
+			apiErrorResponse(w, "query results are restricted", http.StatusForbidden, fmt.Errorf("attempt to download query %s by user %s", name, ctx[ctxUser]))
+			incMetric(metricAPISharesErr)
+			return
+		}
+	}
+	if share.Status != queries.ShareReady {
+		apiErrorResponse(w, "sharing export is not ready", http.StatusConflict, fmt.Errorf("%s is %s", share.Name, share.Status))
+		incMetric(metricAPISharesErr)
+		return
+	}
+	recordActivity(env, environments.ActivityShares, ctx[ctxUser], "sharing export "+share.Name+" downloaded", "", share.SHA256)
+	w.Header().Set(utils.ContentType, queries.ShareContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", share.Name+".zip"))
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(share.Content); err != nil {
+		log.Printf("error sending sharing export %v", err)
+	}
+	// Each query of the export is recorded, so the results of all of them show who downloaded them
+	for _, name := range share.QueryNames() {
+		recordAccess(r, env.ID, environments.AccessQueryResult, name, "sharing export "+share.Name, int64(len(share.Content)))
+	}
+	incMetric(metricAPISharesOK)
+}
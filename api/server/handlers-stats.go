@@ -0,0 +1,164 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/backend"
+	"github.com/jmpsec/osctrl/cache"
+	"github.com/jmpsec/osctrl/metrics"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+const (
+	metricAPIStatsReq = "stats-req"
+	metricAPIStatsErr = "stats-err"
+	metricAPIStatsOK  = "stats-ok"
+)
+
+// GET Handler to return the request statistics of each osctrl-tls instance as JSON
+func apiRequestStatsHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIStatsReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.AdminLevel, users.NoEnvironment) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPIStatsErr)
+		return
+	}
+	snapshots, err := redis.GetRequestStats()
+	if err != nil {
+		apiErrorResponse(w, "error getting request stats", http.StatusInternalServerError, err)
+		incMetric(metricAPIStatsErr)
+		return
+	}
+	// Rows of one window labeled with the instance, if requested
+	var response interface{} = snapshots
+	if window := r.URL.Query().Get("window"); window != "" {
+		response = metrics.InstanceRows(snapshots, window)
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned request stats of %d instances", len(snapshots))
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, response)
+	incMetric(metricAPIStatsOK)
+}
+
+// GET Handler to return the keys in redis by family, with memory estimated from a sample of ?sample= keys of each family
+func apiCacheStatsHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIStatsReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.AdminLevel, users.NoEnvironment) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPIStatsErr)
+		return
+	}
+	sample := cache.StatsSample
+	if v := r.URL.Query().Get("sample"); v != "" {
+		var err error
+		if sample, err = strconv.Atoi(v); err != nil || sample <= 0 {
+			apiErrorResponse(w, "invalid sample", http.StatusBadRequest, fmt.Errorf("sample %s", v))
+			incMetric(metricAPIStatsErr)
+			return
+		}
+	}
+	stats, err := redis.Stats(sample)
+	if err != nil {
+		apiErrorResponse(w, "error getting cache stats", http.StatusInternalServerError, err)
+		incMetric(metricAPIStatsErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned cache stats of %d keys", stats.Keys)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, stats)
+	incMetric(metricAPIStatsOK)
+}
+
+// GET Handler to return the DB connection pools of all the instances reporting, with the ones of this instance first
+func apiPoolStatsHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIStatsReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.AdminLevel, users.NoEnvironment) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPIStatsErr)
+		return
+	}
+	published, err := redis.GetPoolStats()
+	if err != nil {
+		apiErrorResponse(w, "error getting pool stats", http.StatusInternalServerError, err)
+		incMetric(metricAPIStatsErr)
+		return
+	}
+	hostname, _ := os.Hostname()
+	current := backend.InstancePoolStats{Instance: hostname + ":" + apiConfig.Port, Service: settings.ServiceAPI, Pools: db.PoolStats()}
+	stats := []backend.InstancePoolStats{current}
+	for _, s := range published {
+		if s.Service != current.Service || s.Instance != current.Instance {
+			stats = append(stats, s)
+		}
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned pool stats of %d instances", len(stats))
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, stats)
+	incMetric(metricAPIStatsOK)
+}
+
+// GET Handler to return the distribution of clock skew of the nodes of one environment as JSON
+func apiSkewStatsHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIStatsReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	vars := mux.Vars(r)
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPIStatsErr)
+		return
+	}
+	// Get environment by name
+	env, err := envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		if err.Error() == "record not found" {
+			apiErrorResponse(w, "environment not found", http.StatusNotFound, err)
+		} else {
+			apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, err)
+		}
+		incMetric(metricAPIStatsErr)
+		return
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.UserLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPIStatsErr)
+		return
+	}
+	distribution, err := nodesmgr.GetSkewDistribution(env.Name, settingsmgr.ClockSkewSeconds())
+	if err != nil {
+		apiErrorResponse(w, "error getting clock skew", http.StatusInternalServerError, err)
+		incMetric(metricAPIStatsErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned clock skew of %d nodes in %s", distribution.Nodes, env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, distribution)
+	incMetric(metricAPIStatsOK)
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+// Helper to get the key to sign node status tokens, from the secret shared with osctrl-admin that verifies them
+func statusTokenKey() []byte {
+	return nodes.StatusTokenKey(statusTokenSecret)
+}
+
+// POST Handler to generate a status token for a node, to share its status with help desk
+func apiStatusTokenHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPINodesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	// Status tokens share the node status without authentication
+	env, actor, ok := envWithAccess(w, r, users.AdminLevel)
+	if !ok {
+		incMetric(metricAPINodesErr)
+		return
+	}
+	if !settingsmgr.StatusTokens() {
+		apiErrorResponse(w, "status tokens are disabled", http.StatusBadRequest, nil)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	if statusTokenKey() == nil {
+		apiErrorResponse(w, "status tokens need a secret", http.StatusBadRequest, nil)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Get node by identifier
+	nodeVar := mux.Vars(r)["node"]
+	node, err := nodesmgr.GetByIdentifierCtx(r.Context(), nodeVar)
+	if err != nil {
+		if err.Error() == "record not found" {
+			apiErrorResponse(w, "node not found", http.StatusNotFound, err)
+		} else {
+			apiErrorResponse(w, "error getting node", http.StatusInternalServerError, err)
+		}
+		incMetric(metricAPINodesErr)
+		return
+	}
+	if node.EnvironmentID != env.ID {
+		apiErrorResponse(w, "node not found", http.StatusNotFound, fmt.Errorf("node %s not in environment %s", nodeVar, env.Name))
+		incMetric(metricAPINodesErr)
+		return
+	}
+	var t types.ApiStatusTokenRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	// Tokens never live longer than the maximum allowed
+	maxLifetime := time.Duration(settingsmgr.StatusTokenMaxHours()) * time.Hour
+	lifetime := time.Duration(t.Hours) * time.Hour
+	if lifetime <= 0 || lifetime > maxLifetime {
+		lifetime = maxLifetime
+	}
+	now := time.Now()
+	token, err := nodes.NewStatusToken(statusTokenKey(), env.UUID, node.UUID, now, now.Add(lifetime))
+	if err != nil {
+		apiErrorResponse(w, "error generating status token", http.StatusInternalServerError, err)
+		incMetric(metricAPINodesErr)
+		return
+	}
+	expires := nodes.StatusTokenExpiration(token)
+	recordActivity(env, environments.ActivityStatusTokens, actor, fmt.Sprintf("status token for node %s (%s) generated, expires %s", node.Hostname, node.UUID, expires.UTC().Format(time.RFC3339)), "", "")
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Status token generated for node %s", node.UUID)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiStatusTokenResponse{
+		Token:     token,
+		Path:      nodes.StatusTokenPath + token,
+		ExpiresAt: expires,
+	})
+	incMetric(metricAPINodesOK)
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+const (
+	metricAPIStorageReq = "storage-req"
+	metricAPIStorageErr = "storage-err"
+	metricAPIStorageOK  = "storage-ok"
+)
+
+// Helper to describe a storage quota for the activity of the environment
+func quotaSummary(q environments.StorageQuota) string {
+	if q.MaxBytes <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("quota: %d bytes\nactions: %s", q.MaxBytes, q.Actions)
+}
+
+// GET Handler to return the storage of one environment as JSON, with the daily samples filtered with from and to
+func apiEnvStorageHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIStorageReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, _, ok := envWithAccess(w, r, users.UserLevel)
+	if !ok {
+		incMetric(metricAPIStorageErr)
+		return
+	}
+	q := r.URL.Query()
+	now := time.Now()
+	from, err := environments.ParseSince(q.Get("from"), now)
+	if err != nil {
+		apiErrorResponse(w, "invalid from", http.StatusBadRequest, err)
+		incMetric(metricAPIStorageErr)
+		return
+	}
+	if from.IsZero() {
+		from = now.AddDate(0, 0, -environments.DefaultStorageDays)
+	}
+	to, err := environments.ParseSince(q.Get("to"), now)
+	if err != nil {
+		apiErrorResponse(w, "invalid to", http.StatusBadRequest, err)
+		incMetric(metricAPIStorageErr)
+		return
+	}
+	if to.IsZero() {
+		to = now
+	}
+	report, err := envs.StorageReport(env, from, to)
+	if err != nil {
+		apiErrorResponse(w, "error getting storage", http.StatusInternalServerError, err)
+		incMetric(metricAPIStorageErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned storage for %s", env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, report)
+	incMetric(metricAPIStorageOK)
+}
+
+// POST Handler to set the storage quota of one environment and the actions when it is exceeded
+func apiSetStorageQuotaHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIStorageReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, actor, ok := envWithAccess(w, r, users.AdminLevel)
+	if !ok {
+		incMetric(metricAPIStorageErr)
+		return
+	}
+	var q types.ApiStorageQuotaRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPIStorageErr)
+		return
+	}
+	before, err := envs.GetStorageQuota(env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting storage quota", http.StatusInternalServerError, err)
+		incMetric(metricAPIStorageErr)
+		return
+	}
+	quota, err := envs.SetStorageQuota(env.ID, q.MaxBytes, strings.Join(q.Actions, ","), actor)
+	if err != nil {
+		apiErrorResponse(w, "error setting storage quota", http.StatusBadRequest, err)
+		incMetric(metricAPIStorageErr)
+		return
+	}
+	recordActivity(env, environments.ActivityStorage, actor, "storage quota updated", quotaSummary(before), quotaSummary(quota))
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Storage quota of %s updated", env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, quota)
+	incMetric(metricAPIStorageOK)
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+const (
+	metricAPITagsReq = "tags-req"
+	metricAPITagsErr = "tags-err"
+	metricAPITagsOK  = "tags-ok"
+)
+
+// GET Handler for multiple JSON tags
+func apiTagsHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPITagsReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.AdminLevel, users.NoEnvironment) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPITagsErr)
+		return
+	}
+	// Get tags
+	tags, err := tagsmgr.All()
+	if err != nil {
+		apiErrorResponse(w, "error getting tags", http.StatusInternalServerError, err)
+		incMetric(metricAPITagsErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Println("DebugService: Returned tags")
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, tags)
+	incMetric(metricAPITagsOK)
+}
+
+// GET Handler to poll the progress and counts of a tag job
+func apiTagJobHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPITagsReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	vars := mux.Vars(r)
+	// Extract job id
+	idVar, ok := vars["id"]
+	if !ok {
+		apiErrorResponse(w, "error getting tag job", http.StatusInternalServerError, nil)
+		incMetric(metricAPITagsErr)
+		return
+	}
+	id, err := strconv.ParseUint(idVar, 10, 64)
+	if err != nil {
+		apiErrorResponse(w, "invalid tag job", http.StatusBadRequest, err)
+		incMetric(metricAPITagsErr)
+		return
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.AdminLevel, users.NoEnvironment) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPITagsErr)
+		return
+	}
+	job, err := tagsmgr.GetJob(uint(id))
+	if err != nil {
+		apiErrorResponse(w, "error getting tag job", http.StatusNotFound, err)
+		incMetric(metricAPITagsErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned tag job %d", job.ID)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, job)
+	incMetric(metricAPITagsOK)
+}
@@ -0,0 +1,248 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/queries"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	metricAPITemplatesReq = "templates-req"
+	metricAPITemplatesErr = "templates-err"
+	metricAPITemplatesOK  = "templates-ok"
+)
+
+// Helper to get the template of a request by name
+func requestTemplate(w http.ResponseWriter, r *http.Request) (queries.QueryTemplate, bool) {
+	name, ok := mux.Vars(r)["name"]
+	if !ok {
+		apiErrorResponse(w, "error getting name", http.StatusInternalServerError, nil)
+		return queries.QueryTemplate{}, false
+	}
+	t, err := queriesmgr.GetTemplate(name)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			apiErrorResponse(w, "template not found", http.StatusNotFound, err)
+		} else {
+			apiErrorResponse(w, "error getting template", http.StatusInternalServerError, err)
+		}
+		return t, false
+	}
+	return t, true
+}
+
+// Helper to check that the user of a request can modify templates, only admins can
+func templatesAdmin(w http.ResponseWriter, r *http.Request) (string, bool) {
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.AdminLevel, users.NoEnvironment) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to modify templates by user %s", ctx[ctxUser]))
+		return ctx[ctxUser], false
+	}
+	return ctx[ctxUser], true
+}
+
+// Helper to decode a template request into a template
+func decodeTemplateRequest(r *http.Request) (queries.QueryTemplate, string, error) {
+	var t types.ApiQueryTemplateRequest
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		return queries.QueryTemplate{}, "", err
+	}
+	return queries.QueryTemplate{
+		Name:        t.Name,
+		Description: t.Description,
+		Query:       t.Query,
+		Variables:   queries.EncodeTemplateVariables(t.Variables),
+		Platforms:   strings.Join(t.Platforms, ","),
+		Risk:        t.Risk,
+		Team:        t.Team,
+		Approved:    t.Approved,
+	}, t.Changelog, nil
+}
+
+// GET Handler to return all the query templates in JSON
+func apiTemplatesHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPITemplatesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	templates, err := queriesmgr.GetTemplates()
+	if err != nil {
+		apiErrorResponse(w, "error getting templates", http.StatusInternalServerError, err)
+		incMetric(metricAPITemplatesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Println("DebugService: Returned templates")
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, templates)
+	incMetric(metricAPITemplatesOK)
+}
+
+// GET Handler to return one query template in JSON
+func apiTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPITemplatesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	t, ok := requestTemplate(w, r)
+	if !ok {
+		incMetric(metricAPITemplatesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned template %s", t.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, t)
+	incMetric(metricAPITemplatesOK)
+}
+
+// GET Handler to return all the versions of one query template in JSON, also for deleted templates
+func apiTemplateVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPITemplatesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	name, ok := mux.Vars(r)["name"]
+	if !ok {
+		apiErrorResponse(w, "error getting name", http.StatusInternalServerError, nil)
+		incMetric(metricAPITemplatesErr)
+		return
+	}
+	versions, err := queriesmgr.GetTemplateVersions(name)
+	if err != nil {
+		apiErrorResponse(w, "error getting template versions", http.StatusInternalServerError, err)
+		incMetric(metricAPITemplatesErr)
+		return
+	}
+	if len(versions) == 0 {
+		apiErrorResponse(w, "template not found", http.StatusNotFound, nil)
+		incMetric(metricAPITemplatesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned versions of template %s", name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, versions)
+	incMetric(metricAPITemplatesOK)
+}
+
+// POST Handler to create a new query template
+func apiTemplateCreateHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPITemplatesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	actor, ok := templatesAdmin(w, r)
+	if !ok {
+		incMetric(metricAPITemplatesErr)
+		return
+	}
+	t, changelog, err := decodeTemplateRequest(r)
+	if err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPITemplatesErr)
+		return
+	}
+	if _, err := queriesmgr.GetTemplate(t.Name); err == nil {
+		apiErrorResponse(w, "template already exists", http.StatusConflict, nil)
+		incMetric(metricAPITemplatesErr)
+		return
+	}
+	t.Creator = actor
+	t, err = queriesmgr.CreateTemplate(t, changelog)
+	if err != nil {
+		apiErrorResponse(w, "error creating template", http.StatusBadRequest, err)
+		incMetric(metricAPITemplatesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Created template %s", t.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, t)
+	incMetric(metricAPITemplatesOK)
+}
+
+// POST Handler to update an existing query template as a new version
+func apiTemplateUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPITemplatesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	actor, ok := templatesAdmin(w, r)
+	if !ok {
+		incMetric(metricAPITemplatesErr)
+		return
+	}
+	current, ok := requestTemplate(w, r)
+	if !ok {
+		incMetric(metricAPITemplatesErr)
+		return
+	}
+	t, changelog, err := decodeTemplateRequest(r)
+	if err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPITemplatesErr)
+		return
+	}
+	t, err = queriesmgr.UpdateTemplate(current.Name, t, actor, changelog)
+	if err != nil {
+		apiErrorResponse(w, "error updating template", http.StatusBadRequest, err)
+		incMetric(metricAPITemplatesErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Updated template %s to version %d", t.Name, t.Version)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, t)
+	incMetric(metricAPITemplatesOK)
+}
+
+// POST Handler to delete a query template, keeping its versions
+func apiTemplateDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPITemplatesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	user, ok := templatesAdmin(w, r)
+	if !ok {
+		incMetric(metricAPITemplatesErr)
+		return
+	}
+	hard, ok := hardDelete(w, r)
+	if !ok {
+		incMetric(metricAPITemplatesErr)
+		return
+	}
+	name := mux.Vars(r)["name"]
+	var err error
+	msg := fmt.Sprintf("template %s moved to the trash", name)
+	if hard {
+		// Templates already in the trash can be deleted permanently
+		err = queriesmgr.HardDeleteTemplate(name, user)
+		msg = fmt.Sprintf("template %s deleted permanently", name)
+	} else {
+		t, found := requestTemplate(w, r)
+		if !found {
+			incMetric(metricAPITemplatesErr)
+			return
+		}
+		err = queriesmgr.DeleteTemplate(t.Name, user)
+	}
+	if err != nil {
+		apiErrorResponse(w, "error deleting template", http.StatusInternalServerError, err)
+		incMetric(metricAPITemplatesErr)
+		return
+	}
+	if hard {
+		log.Printf("Template %s deleted permanently by %s", name, user)
+	}
+	// Return message as serialized response
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: %s", msg)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiGenericResponse{Message: msg})
+	incMetric(metricAPITemplatesOK)
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/backend"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/queries"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+const (
+	metricAPITrashReq = "trash-req"
+	metricAPITrashErr = "trash-err"
+	metricAPITrashOK  = "trash-ok"
+)
+
+// Helper to check if a delete skips the trash with ?hard=true, only admin users can do it
+func hardDelete(w http.ResponseWriter, r *http.Request) (bool, bool) {
+	if r.URL.Query().Get("hard") != "true" {
+		return false, true
+	}
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.AdminLevel, users.NoEnvironment) {
+		apiErrorResponse(w, "only admins can delete permanently", http.StatusForbidden, fmt.Errorf("attempt to delete permanently by user %s", ctx[ctxUser]))
+		return true, false
+	}
+	return true, true
+}
+
+// POST Handler to delete a query or carve, moved to the trash unless ?hard=true
+func apiQueryDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIQueriesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, user, ok := envWithAccess(w, r, users.QueryLevel)
+	if !ok {
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	name, ok := mux.Vars(r)["name"]
+	if !ok {
+		apiErrorResponse(w, "error getting name", http.StatusInternalServerError, nil)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	hard, ok := hardDelete(w, r)
+	if !ok {
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	kind, activity := backend.TrashQueries, environments.ActivityQueries
+	query, err := queriesmgr.GetCtx(r.Context(), name, env.ID)
+	if err == nil && query.Type == queries.CarveQueryType {
+		kind, activity = backend.TrashCarves, environments.ActivityCarves
+	}
+	msg := fmt.Sprintf("query %s moved to the trash", name)
+	if hard {
+		err = queriesmgr.HardDelete(name, env.ID, kind, user)
+		msg = fmt.Sprintf("query %s deleted permanently", name)
+	} else {
+		err = queriesmgr.Delete(name, env.ID, user)
+	}
+	if err != nil {
+		apiErrorResponse(w, "error deleting query", http.StatusBadRequest, err)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	if hard {
+		log.Printf("Query %s of %s deleted permanently by %s", name, env.Name, user)
+		recordActivity(env, activity, user, msg, name, "")
+	}
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: %s", msg)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiGenericResponse{Message: msg})
+	incMetric(metricAPIQueriesOK)
+}
+
+// GET Handler to return the items in the trash of an environment as JSON, newest first
+func apiTrashHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPITrashReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, user, ok := envWithAccess(w, r, users.QueryLevel)
+	if !ok {
+		incMetric(metricAPITrashErr)
+		return
+	}
+	days := settingsmgr.TrashRetentionDays()
+	items, err := queriesmgr.TrashItems(env.ID, user, days, time.Now())
+	if err != nil {
+		apiErrorResponse(w, "error getting trash", http.StatusInternalServerError, err)
+		incMetric(metricAPITrashErr)
+		return
+	}
+	tagItems, err := tagsmgr.TrashItems(days, time.Now())
+	if err != nil {
+		apiErrorResponse(w, "error getting trash", http.StatusInternalServerError, err)
+		incMetric(metricAPITrashErr)
+		return
+	}
+	items = append(items, tagItems...)
+	sort.SliceStable(items, func(i, j int) bool { return items[i].DeletedAt.After(items[j].DeletedAt) })
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned %d items in the trash", len(items))
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, items)
+	incMetric(metricAPITrashOK)
+}
+
+// POST Handler to restore an item from the trash of an environment
+func apiTrashRestoreHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPITrashReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, user, ok := envWithAccess(w, r, users.QueryLevel)
+	if !ok {
+		incMetric(metricAPITrashErr)
+		return
+	}
+	var t types.ApiTrashRequest
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPITrashErr)
+		return
+	}
+	// Templates and tags are not scoped to an environment, they are only managed by admins
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if (t.Kind == backend.TrashTemplates || t.Kind == backend.TrashTags) && !checkAccess(ctx, users.AdminLevel, users.NoEnvironment) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to restore %s %s by user %s", t.Kind, t.Name, user))
+		incMetric(metricAPITrashErr)
+		return
+	}
+	var err error
+	switch t.Kind {
+	case backend.TrashQueries, backend.TrashCarves:
+		err = queriesmgr.Restore(t.Name, env.ID)
+	case backend.TrashSaved:
+		err = queriesmgr.RestoreSaved(t.Name, user, env.ID)
+	case backend.TrashTemplates:
+		err = queriesmgr.RestoreTemplate(t.Name)
+	case backend.TrashTags:
+		err = tagsmgr.Restore(t.Name, user)
+	default:
+		err = fmt.Errorf("invalid kind %s", t.Kind)
+	}
+	if err != nil {
+		apiErrorResponse(w, "error restoring from the trash", http.StatusBadRequest, err)
+		incMetric(metricAPITrashErr)
+		return
+	}
+	msg := fmt.Sprintf("%s %s restored", t.Kind, t.Name)
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: %s", msg)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiGenericResponse{Message: msg})
+	incMetric(metricAPITrashOK)
+}
@@ -0,0 +1,254 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+const (
+	metricAPIUsersReq = "users-req"
+	metricAPIUsersErr = "users-err"
+	metricAPIUsersOK  = "users-ok"
+)
+
+// GET Handler for single JSON nodes
+func apiUserHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPINodesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	vars := mux.Vars(r)
+	// Extract username
+	usernameVar, ok := vars["username"]
+	if !ok {
+		apiErrorResponse(w, "error with username", http.StatusInternalServerError, nil)
+		incMetric(metricAPIUsersErr)
+		return
+	}
+	// Get user
+	user, err := apiUsers.Get(usernameVar)
+	if err != nil {
+		apiErrorResponse(w, "error getting user", http.StatusInternalServerError, nil)
+		incMetric(metricAPIUsersErr)
+		return
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.AdminLevel, users.NoEnvironment) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPIUsersErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned user %s", usernameVar)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, user)
+	incMetric(metricAPIUsersOK)
+}
+
+// GET Handler for multiple JSON nodes
+func apiUsersHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPINodesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.AdminLevel, users.NoEnvironment) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPIUsersErr)
+		return
+	}
+	// Get users
+	users, err := apiUsers.All()
+	if err != nil {
+		apiErrorResponse(w, "error getting users", http.StatusInternalServerError, err)
+		incMetric(metricAPIUsersErr)
+		return
+	}
+	if len(users) == 0 {
+		apiErrorResponse(w, "no users", http.StatusNotFound, nil)
+		incMetric(metricAPIUsersErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Println("DebugService: Returned users")
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, users)
+	incMetric(metricAPIUsersOK)
+}
+
+// GET Handler for the permissions of a user in all environments
+func apiUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIUsersReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	vars := mux.Vars(r)
+	// Extract username
+	usernameVar, ok := vars["username"]
+	if !ok {
+		apiErrorResponse(w, "error with username", http.StatusInternalServerError, nil)
+		incMetric(metricAPIUsersErr)
+		return
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.AdminLevel, users.NoEnvironment) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPIUsersErr)
+		return
+	}
+	if !apiUsers.Exists(usernameVar) {
+		apiErrorResponse(w, "user not found", http.StatusNotFound, nil)
+		incMetric(metricAPIUsersErr)
+		return
+	}
+	access, err := apiUsers.GetAccess(usernameVar)
+	if err != nil {
+		apiErrorResponse(w, "error getting permissions", http.StatusInternalServerError, err)
+		incMetric(metricAPIUsersErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned permissions for user %s", usernameVar)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, access)
+	incMetric(metricAPIUsersOK)
+}
+
+// PUT Handler to replace all the permissions of a user with the provided ones, by environment name or UUID
+func apiUserPermissionsPutHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIUsersReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	vars := mux.Vars(r)
+	// Extract username
+	usernameVar, ok := vars["username"]
+	if !ok {
+		apiErrorResponse(w, "error with username", http.StatusInternalServerError, nil)
+		incMetric(metricAPIUsersErr)
+		return
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.AdminLevel, users.NoEnvironment) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPIUsersErr)
+		return
+	}
+	if !apiUsers.Exists(usernameVar) {
+		apiErrorResponse(w, "user not found", http.StatusNotFound, nil)
+		incMetric(metricAPIUsersErr)
+		return
+	}
+	var access users.UserAccess
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&access); err != nil {
+		apiErrorResponse(w, "error parsing PUT body", http.StatusBadRequest, err)
+		incMetric(metricAPIUsersErr)
+		return
+	}
+	envsMap, err := envs.GetMapCtx(r.Context())
+	if err != nil {
+		apiErrorResponse(w, "error getting environments", http.StatusInternalServerError, err)
+		incMetric(metricAPIUsersErr)
+		return
+	}
+	// All environments must exist before anything is changed
+	resolved, err := users.ResolveAccess(envsMap, access)
+	if err != nil {
+		apiErrorResponse(w, err.Error(), http.StatusBadRequest, err)
+		incMetric(metricAPIUsersErr)
+		return
+	}
+	before, after, err := apiUsers.SetAccess(usernameVar, ctx[ctxUser], resolved)
+	if err != nil {
+		apiErrorResponse(w, "error setting permissions", http.StatusInternalServerError, err)
+		incMetric(metricAPIUsersErr)
+		return
+	}
+	if err := users.RecordAccessChanges(envs, usernameVar, ctx[ctxUser], before, after); err != nil {
+		log.Printf("error recording permissions activity %v", err)
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Permissions set for user %s", usernameVar)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, users.AccessChange{Before: before, After: after})
+	incMetric(metricAPIUsersOK)
+}
+
+// GET Handler to get the active lockouts after failed logins
+func apiLockoutsHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIUsersReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.AdminLevel, users.NoEnvironment) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPIUsersErr)
+		return
+	}
+	lockouts, err := apiUsers.ActiveLockouts()
+	if err != nil {
+		apiErrorResponse(w, "error getting lockouts", http.StatusInternalServerError, err)
+		incMetric(metricAPIUsersErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Println("DebugService: Returned lockouts")
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, lockouts)
+	incMetric(metricAPIUsersOK)
+}
+
+// POST Handler to unlock a username or an IP address locked out after failed logins
+func apiUnlockHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIUsersReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.AdminLevel, users.NoEnvironment) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPIUsersErr)
+		return
+	}
+	var u types.ApiUnlockRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusBadRequest, err)
+		incMetric(metricAPIUsersErr)
+		return
+	}
+	if u.Username == "" && u.IPAddress == "" {
+		apiErrorResponse(w, "username or IP address is required", http.StatusBadRequest, nil)
+		incMetric(metricAPIUsersErr)
+		return
+	}
+	var unlocked int64
+	subjects := map[string]string{users.LoginUser: u.Username, users.LoginIP: u.IPAddress}
+	for kind, subject := range subjects {
+		if subject == "" {
+			continue
+		}
+		n, err := apiUsers.UnlockLogin(kind, subject, ctx[ctxUser])
+		if err != nil {
+			apiErrorResponse(w, "error unlocking", http.StatusInternalServerError, err)
+			incMetric(metricAPIUsersErr)
+			return
+		}
+		unlocked += n
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: %d lockouts unlocked", unlocked)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiGenericResponse{Message: fmt.Sprintf("%d lockouts unlocked", unlocked)})
+	incMetric(metricAPIUsersOK)
+}
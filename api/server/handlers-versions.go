@@ -0,0 +1,235 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+const (
+	metricAPIVersionsReq = "versions-req"
+	metricAPIVersionsErr = "versions-err"
+	metricAPIVersionsOK  = "versions-ok"
+)
+
+// GET Handler to return the configuration versions of one environment as JSON
+func apiConfigVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIVersionsReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, _, ok := envWithAccess(w, r, users.AdminLevel)
+	if !ok {
+		incMetric(metricAPIVersionsErr)
+		return
+	}
+	versions, err := envs.ConfigVersions(env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting configuration versions", http.StatusInternalServerError, err)
+		incMetric(metricAPIVersionsErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned configuration versions for %s", env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, versions)
+	incMetric(metricAPIVersionsOK)
+}
+
+// POST Handler to rollback the configuration of one environment to a version
+func apiConfigRollbackHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIVersionsReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, actor, ok := envWithAccess(w, r, users.AdminLevel)
+	if !ok {
+		incMetric(metricAPIVersionsErr)
+		return
+	}
+	var rb types.ApiRollbackRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&rb); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPIVersionsErr)
+		return
+	}
+	// Rollbacks need a note when the policy of the category of the environment asks for it
+	if err := categoryPolicy(env).CheckNote(rb.Note); err != nil {
+		apiErrorResponse(w, err.Error(), http.StatusBadRequest, nil)
+		incMetric(metricAPIVersionsErr)
+		return
+	}
+	// The rollback is recorded as activity of the environment
+	version, err := envs.RollbackConfig(env.UUID, rb.Version, actor, rb.Note)
+	if err != nil {
+		apiErrorResponse(w, "error rolling back configuration", http.StatusBadRequest, err)
+		incMetric(metricAPIVersionsErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Configuration of %s rolled back to version %d", env.Name, rb.Version)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, version)
+	incMetric(metricAPIVersionsOK)
+}
+
+// POST Handler to preview the active nodes receiving a change of the configuration of one environment, without saving it
+func apiConfigImpactHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIVersionsReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, _, ok := envWithAccess(w, r, users.AdminLevel)
+	if !ok {
+		incMetric(metricAPIVersionsErr)
+		return
+	}
+	var c types.ApiConfigImpactRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusBadRequest, err)
+		incMetric(metricAPIVersionsErr)
+		return
+	}
+	proposed, err := envs.ProposedConfig(env, c.Section, c.Value)
+	if err != nil {
+		apiErrorResponse(w, "invalid configuration", http.StatusBadRequest, err)
+		incMetric(metricAPIVersionsErr)
+		return
+	}
+	// Active nodes come from the node counts snapshots, not from counting nodes
+	counts, day, err := nodesmgr.CachedCounts(env.ID, settingsmgr.InactiveHours())
+	if err != nil {
+		apiErrorResponse(w, "error getting node counts", http.StatusInternalServerError, err)
+		incMetric(metricAPIVersionsErr)
+		return
+	}
+	impact, err := envs.ConfigImpact(env, proposed, nodes.ActivePlatforms(counts))
+	if err != nil {
+		apiErrorResponse(w, "invalid configuration", http.StatusBadRequest, err)
+		incMetric(metricAPIVersionsErr)
+		return
+	}
+	impact.CountsDay = day
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned configuration impact for %s", env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, impact)
+	incMetric(metricAPIVersionsOK)
+}
+
+// GET Handler to return the conflicts between options, schedule and packs in the configuration of one environment
+func apiConfigConflictsHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIVersionsReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, _, ok := envWithAccess(w, r, users.AdminLevel)
+	if !ok {
+		incMetric(metricAPIVersionsErr)
+		return
+	}
+	conflicts, err := environments.DetectConflicts(env, settingsmgr.QueryBudget())
+	if err != nil {
+		apiErrorResponse(w, "error detecting configuration conflicts", http.StatusInternalServerError, err)
+		incMetric(metricAPIVersionsErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned %d configuration conflicts for %s", len(conflicts), env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, conflicts)
+	incMetric(metricAPIVersionsOK)
+}
+
+// POST Handler to verify the flags and configuration served by one environment against osquery versions, with
+// ?version= for one version or the versions of the nodes otherwise. A change of one section can be sent to verify it
+// before saving it, and ?platform= and ?profile= select what is rendered.
+func apiConfigVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIVersionsReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, _, ok := envWithAccess(w, r, users.AdminLevel)
+	if !ok {
+		incMetric(metricAPIVersionsErr)
+		return
+	}
+	var c types.ApiConfigImpactRequest
+	// Parse request JSON body, empty to verify the current configuration
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil && err != io.EOF {
+		apiErrorResponse(w, "error parsing POST body", http.StatusBadRequest, err)
+		incMetric(metricAPIVersionsErr)
+		return
+	}
+	if c.Section != "" {
+		proposed, err := envs.ProposedServed(env, c.Section, c.Value)
+		if err != nil {
+			apiErrorResponse(w, "invalid configuration", http.StatusBadRequest, err)
+			incMetric(metricAPIVersionsErr)
+			return
+		}
+		env = proposed
+	}
+	version := r.URL.Query().Get("version")
+	if version != "" && !environments.ValidOsqueryVersion(version) {
+		apiErrorResponse(w, "invalid osquery version", http.StatusBadRequest, nil)
+		incMetric(metricAPIVersionsErr)
+		return
+	}
+	platform := r.URL.Query().Get("platform")
+	var profile environments.PerformanceProfile
+	if name := r.URL.Query().Get("profile"); name != "" {
+		profiles, err := envs.ProfilesCtx(r.Context(), env.ID)
+		if err != nil {
+			apiErrorResponse(w, "error getting profiles", http.StatusInternalServerError, err)
+			incMetric(metricAPIVersionsErr)
+			return
+		}
+		found := false
+		for _, p := range profiles {
+			if p.Name == name {
+				profile, found = p, true
+			}
+		}
+		if !found {
+			apiErrorResponse(w, "profile not found", http.StatusNotFound, nil)
+			incMetric(metricAPIVersionsErr)
+			return
+		}
+	}
+	// Versions present in the fleet come from the nodes, with the versions pinned for their platforms
+	report, err := nodesmgr.GetVersionReport(env.ID, func(string) string { return "" })
+	if err != nil {
+		apiErrorResponse(w, "error getting osquery versions", http.StatusInternalServerError, err)
+		incMetric(metricAPIVersionsErr)
+		return
+	}
+	var fleet []environments.VerifyTarget
+	for _, v := range report.Versions {
+		fleet = append(fleet, environments.VerifyTarget{Platform: v.Platform, Version: v.Version})
+	}
+	targets := environments.SelectTargets(env, fleet, platform, version)
+	channels, err := envs.WindowsEventsCtx(r.Context(), env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting windows event channels", http.StatusInternalServerError, err)
+		incMetric(metricAPIVersionsErr)
+		return
+	}
+	checker := environments.NewOsquerydChecker(settingsmgr.OsquerydCheckPath())
+	verification, err := envs.VerifyServed(r.Context(), env, profile, channels, targets, checker)
+	if err != nil {
+		apiErrorResponse(w, "error verifying configuration", http.StatusBadRequest, err)
+		incMetric(metricAPIVersionsErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Verified configuration of %s for %d targets", env.Name, len(targets))
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, verification)
+	incMetric(metricAPIVersionsOK)
+}
@@ -0,0 +1,250 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/queries"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+// Helper to get the user of the request as a viewer of queries, with the teams of the user
+func apiViewer(ctx contextValue) queries.Viewer {
+	teams, err := apiUsers.UserTeams(ctx[ctxUser])
+	if err != nil {
+		log.Printf("error getting teams of %s %v", ctx[ctxUser], err)
+	}
+	return queries.Viewer{Username: ctx[ctxUser], Teams: teams}
+}
+
+// Helper to check if the user of the request can see the results of a query, errors do not give access
+func apiCanView(rctx context.Context, ctx contextValue, query queries.DistributedQuery) bool {
+	ok, err := queriesmgr.CanView(rctx, query, apiViewer(ctx))
+	if err != nil {
+		log.Printf("error checking visibility of query %s %v", query.Name, err)
+		return false
+	}
+	return ok
+}
+
+// Helper to check if the user of the request can see the results of a query by name
+func apiCanViewName(rctx context.Context, ctx contextValue, name string, envid uint) bool {
+	ok, err := queriesmgr.CanViewName(rctx, name, envid, apiViewer(ctx))
+	if err != nil {
+		log.Printf("error checking visibility of query %s %v", name, err)
+		return false
+	}
+	return ok
+}
+
+// Helper to list queries or carves by target as they are listed to the user of the request
+func apiListQueries(rctx context.Context, ctx contextValue, target, qtype string, envid uint) ([]queries.DistributedQuery, error) {
+	return queriesmgr.ListCtx(rctx, target, qtype, envid, apiViewer(ctx), settingsmgr.RestrictedListing())
+}
+
+// POST Handler to change who can see the results of a query, only for the creator of the query and admins
+func apiQueryVisibilityHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIQueriesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	vars := mux.Vars(r)
+	// Extract name
+	name, ok := vars["name"]
+	if !ok {
+		apiErrorResponse(w, "error getting name", http.StatusInternalServerError, nil)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		apiErrorResponse(w, "error with environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Get environment
+	env, err := envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.QueryLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	query, err := queriesmgr.GetCtx(r.Context(), name, env.ID)
+	if err != nil || query.ID == 0 {
+		apiErrorResponse(w, "query not found", http.StatusNotFound, err)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	if query.Creator != ctx[ctxUser] && !checkAccess(ctx, users.AdminLevel, env.UUID) {
+		apiErrorResponse(w, "only the creator and admins can change the visibility", http.StatusForbidden, fmt.Errorf("attempt to change query %s by user %s", name, ctx[ctxUser]))
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	var v types.ApiQueryVisibilityRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	before, err := queriesmgr.SetVisibility(name, env.ID, v.Visibility, v.Team)
+	if err != nil {
+		apiErrorResponse(w, "error setting query visibility", http.StatusBadRequest, err)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	after := queries.DistributedQuery{Visibility: v.Visibility, VisibilityTeam: v.Team}
+	recordActivity(env, environments.ActivityQueries, ctx[ctxUser], "visibility of query "+name+" changed", before.VisibilitySummary(), after.VisibilitySummary())
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Changed visibility of query %s", name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiGenericResponse{Message: "query visibility saved successfully"})
+	incMetric(metricAPIQueriesOK)
+}
+
+// POST Handler for admins to see the results of a restricted query for a while, with a reason that is audited
+func apiQueryBreakGlassHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIQueriesReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	vars := mux.Vars(r)
+	// Extract name
+	name, ok := vars["name"]
+	if !ok {
+		apiErrorResponse(w, "error getting name", http.StatusInternalServerError, nil)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Extract environment
+	envVar, ok := vars["env"]
+	if !ok {
+		apiErrorResponse(w, "error with environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Get environment
+	env, err := envs.GetCtx(r.Context(), envVar)
+	if err != nil {
+		apiErrorResponse(w, "error getting environment", http.StatusInternalServerError, nil)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	// Get context data and check access, only admins can break the glass
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.AdminLevel, env.UUID) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	query, err := queriesmgr.GetCtx(r.Context(), name, env.ID)
+	if err != nil || query.ID == 0 {
+		apiErrorResponse(w, "query not found", http.StatusNotFound, err)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	var b types.ApiBreakGlassRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	if query.VisibleTo(apiViewer(ctx)) {
+		apiErrorResponse(w, "query results are already visible", http.StatusBadRequest, nil)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	grant, err := queriesmgr.BreakGlass(query, ctx[ctxUser], b.Reason)
+	if err != nil {
+		apiErrorResponse(w, "error breaking the glass", http.StatusBadRequest, err)
+		incMetric(metricAPIQueriesErr)
+		return
+	}
+	recordActivity(env, environments.ActivityQueries, ctx[ctxUser], fmt.Sprintf("break glass to see results of query %s (%s) until %s: %s", query.Name, query.VisibilitySummary(), grant.ExpiresAt.Format("2006-01-02 15:04:05"), grant.Reason), "", "")
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Break glass for query %s", name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, grant)
+	incMetric(metricAPIQueriesOK)
+}
+
+// GET Handler to return the teams with their members
+func apiTeamsHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIUsersReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.AdminLevel, users.NoEnvironment) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPIUsersErr)
+		return
+	}
+	teams, err := apiUsers.Teams()
+	if err != nil {
+		apiErrorResponse(w, "error getting teams", http.StatusInternalServerError, err)
+		incMetric(metricAPIUsersErr)
+		return
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, teams)
+	incMetric(metricAPIUsersOK)
+}
+
+// POST Handler to add users to teams and remove them
+func apiTeamMemberHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIUsersReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	// Get context data and check access
+	ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+	if !checkAccess(ctx, users.AdminLevel, users.NoEnvironment) {
+		apiErrorResponse(w, "no access", http.StatusForbidden, fmt.Errorf("attempt to use API by user %s", ctx[ctxUser]))
+		incMetric(metricAPIUsersErr)
+		return
+	}
+	var t types.ApiTeamMemberRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPIUsersErr)
+		return
+	}
+	var msg string
+	switch t.Action {
+	case "add":
+		if err := apiUsers.AddTeamMember(t.Team, t.Username, ctx[ctxUser]); err != nil {
+			apiErrorResponse(w, "error adding to team", http.StatusBadRequest, err)
+			incMetric(metricAPIUsersErr)
+			return
+		}
+		log.Printf("user %s added to team %s by %s", t.Username, t.Team, ctx[ctxUser])
+		msg = "user added to team successfully"
+	case "remove":
+		if err := apiUsers.RemoveTeamMember(t.Team, t.Username); err != nil {
+			apiErrorResponse(w, "error removing from team", http.StatusBadRequest, err)
+			incMetric(metricAPIUsersErr)
+			return
+		}
+		log.Printf("user %s removed from team %s by %s", t.Username, t.Team, ctx[ctxUser])
+		msg = "user removed from team successfully"
+	default:
+		apiErrorResponse(w, "invalid action", http.StatusBadRequest, fmt.Errorf("action %s", t.Action))
+		incMetric(metricAPIUsersErr)
+		return
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiGenericResponse{Message: msg})
+	incMetric(metricAPIUsersOK)
+}
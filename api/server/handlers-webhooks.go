@@ -0,0 +1,292 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+	"github.com/jmpsec/osctrl/webhooks"
+)
+
+const (
+	metricAPIWebhooksReq = "webhooks-req"
+	metricAPIWebhooksErr = "webhooks-err"
+	metricAPIWebhooksOK  = "webhooks-ok"
+)
+
+// Default number of deliveries of an endpoint returned
+const defaultWebhookDeliveries = 100
+
+// Helper to get the webhook endpoint of the request, only for environment admins
+func webhookFromRequest(w http.ResponseWriter, r *http.Request) (environments.TLSEnvironment, webhooks.WebhookEndpoint, string, bool) {
+	env, actor, ok := envWithAccess(w, r, users.AdminLevel)
+	if !ok {
+		return env, webhooks.WebhookEndpoint{}, actor, false
+	}
+	name := mux.Vars(r)["name"]
+	e, err := webhooksmgr.GetEndpoint(env.ID, name)
+	if err != nil {
+		apiErrorResponse(w, "endpoint not found", http.StatusNotFound, err)
+		return env, e, actor, false
+	}
+	return env, e, actor, true
+}
+
+// GET Handler to return the webhook endpoints of one environment as JSON, without secrets
+func apiWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIWebhooksReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, _, ok := envWithAccess(w, r, users.AdminLevel)
+	if !ok {
+		incMetric(metricAPIWebhooksErr)
+		return
+	}
+	endpoints, err := webhooksmgr.Endpoints(env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting endpoints", http.StatusInternalServerError, err)
+		incMetric(metricAPIWebhooksErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned webhook endpoints for %s", env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, endpoints)
+	incMetric(metricAPIWebhooksOK)
+}
+
+// POST Handler to create a webhook endpoint in one environment, the secret is only returned here
+func apiCreateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIWebhooksReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, actor, ok := envWithAccess(w, r, users.AdminLevel)
+	if !ok {
+		incMetric(metricAPIWebhooksErr)
+		return
+	}
+	var req types.ApiWebhookRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPIWebhooksErr)
+		return
+	}
+	before, err := webhooksmgr.Endpoints(env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting endpoints", http.StatusInternalServerError, err)
+		incMetric(metricAPIWebhooksErr)
+		return
+	}
+	e, err := webhooksmgr.CreateEndpoint(env.ID, webhooks.WebhookEndpoint{
+		Name:    req.Name,
+		URL:     req.URL,
+		Events:  strings.Join(req.Events, ","),
+		Creator: actor,
+	})
+	if err != nil {
+		apiErrorResponse(w, "error creating endpoint", http.StatusBadRequest, err)
+		incMetric(metricAPIWebhooksErr)
+		return
+	}
+	recordActivity(env, environments.ActivityWebhooks, actor, "webhook endpoint "+e.Name+" created", webhooks.SerializeEndpoints(before), webhooks.SerializeEndpoints(append(before, e)))
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Created webhook endpoint %s", e.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiWebhookSecretResponse{Name: e.Name, Secret: e.Secret})
+	incMetric(metricAPIWebhooksOK)
+}
+
+// POST Handler to rotate the secret of a webhook endpoint, the new secret is only returned here
+func apiRotateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIWebhooksReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, e, actor, ok := webhookFromRequest(w, r)
+	if !ok {
+		incMetric(metricAPIWebhooksErr)
+		return
+	}
+	e, err := webhooksmgr.RotateSecret(env.ID, e.Name)
+	if err != nil {
+		apiErrorResponse(w, "error rotating secret", http.StatusInternalServerError, err)
+		incMetric(metricAPIWebhooksErr)
+		return
+	}
+	recordActivity(env, environments.ActivityWebhooks, actor, "secret of webhook endpoint "+e.Name+" rotated", "", "")
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Rotated secret of webhook endpoint %s", e.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiWebhookSecretResponse{Name: e.Name, Secret: e.Secret})
+	incMetric(metricAPIWebhooksOK)
+}
+
+// POST Handler to enable, disable or delete a webhook endpoint
+func apiWebhookActionHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIWebhooksReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, e, actor, ok := webhookFromRequest(w, r)
+	if !ok {
+		incMetric(metricAPIWebhooksErr)
+		return
+	}
+	before, err := webhooksmgr.Endpoints(env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting endpoints", http.StatusInternalServerError, err)
+		incMetric(metricAPIWebhooksErr)
+		return
+	}
+	action := mux.Vars(r)["action"]
+	switch action {
+	case "enable", "disable":
+		_, err = webhooksmgr.SetEnabled(env.ID, e.Name, action == "enable")
+	case "delete":
+		err = webhooksmgr.DeleteEndpoint(env.ID, e.Name)
+	default:
+		apiErrorResponse(w, "invalid action", http.StatusBadRequest, nil)
+		incMetric(metricAPIWebhooksErr)
+		return
+	}
+	if err != nil {
+		apiErrorResponse(w, "error changing endpoint", http.StatusInternalServerError, err)
+		incMetric(metricAPIWebhooksErr)
+		return
+	}
+	after, err := webhooksmgr.Endpoints(env.ID)
+	if err != nil {
+		log.Printf("error getting webhook endpoints %v", err)
+	}
+	msg := "webhook endpoint " + e.Name + " " + action + "d"
+	recordActivity(env, environments.ActivityWebhooks, actor, msg, webhooks.SerializeEndpoints(before), webhooks.SerializeEndpoints(after))
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: %s", msg)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiGenericResponse{Message: msg})
+	incMetric(metricAPIWebhooksOK)
+}
+
+// POST Handler to send a test event to a webhook endpoint right away, returning the delivery
+func apiTestWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIWebhooksReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, e, actor, ok := webhookFromRequest(w, r)
+	if !ok {
+		incMetric(metricAPIWebhooksErr)
+		return
+	}
+	d, err := webhooksmgr.TestFire(env.ID, e.Name, actor)
+	if err != nil {
+		apiErrorResponse(w, "error sending test event", http.StatusInternalServerError, err)
+		incMetric(metricAPIWebhooksErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Test event for webhook endpoint %s %s", e.Name, d.State)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, d)
+	incMetric(metricAPIWebhooksOK)
+}
+
+// GET Handler to return the latest deliveries of a webhook endpoint, only with ?state= if set
+func apiWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIWebhooksReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	_, e, _, ok := webhookFromRequest(w, r)
+	if !ok {
+		incMetric(metricAPIWebhooksErr)
+		return
+	}
+	state := r.URL.Query().Get("state")
+	if state != "" && state != webhooks.StatePending && state != webhooks.StateDelivered && state != webhooks.StateFailed {
+		apiErrorResponse(w, "invalid state", http.StatusBadRequest, fmt.Errorf("state %s", state))
+		incMetric(metricAPIWebhooksErr)
+		return
+	}
+	limit := defaultWebhookDeliveries
+	if l := r.URL.Query().Get("limit"); l != "" {
+		v, err := strconv.Atoi(l)
+		if err != nil || v < 0 {
+			apiErrorResponse(w, "invalid limit", http.StatusBadRequest, err)
+			incMetric(metricAPIWebhooksErr)
+			return
+		}
+		limit = v
+	}
+	deliveries, err := webhooksmgr.Deliveries(e.ID, state, limit)
+	if err != nil {
+		apiErrorResponse(w, "error getting deliveries", http.StatusInternalServerError, err)
+		incMetric(metricAPIWebhooksErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned deliveries of webhook endpoint %s", e.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, deliveries)
+	incMetric(metricAPIWebhooksOK)
+}
+
+// POST Handler to queue again a delivery of a webhook endpoint
+func apiRedeliverWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIWebhooksReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, e, actor, ok := webhookFromRequest(w, r)
+	if !ok {
+		incMetric(metricAPIWebhooksErr)
+		return
+	}
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		apiErrorResponse(w, "invalid delivery", http.StatusBadRequest, err)
+		incMetric(metricAPIWebhooksErr)
+		return
+	}
+	d, err := webhooksmgr.Redeliver(e.ID, uint(id))
+	if err != nil {
+		apiErrorResponse(w, "error redelivering", http.StatusBadRequest, err)
+		incMetric(metricAPIWebhooksErr)
+		return
+	}
+	recordActivity(env, environments.ActivityWebhooks, actor, fmt.Sprintf("delivery %d of webhook endpoint %s queued again", d.ID, e.Name), "", "")
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Redelivery %d of webhook endpoint %s", d.ID, e.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, d)
+	incMetric(metricAPIWebhooksOK)
+}
+
+// GET Handler to return all the webhook attempts for an entity, like a query or a carve, to debug integrations
+func apiWebhookHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIWebhooksReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, _, ok := envWithAccess(w, r, users.AdminLevel)
+	if !ok {
+		incMetric(metricAPIWebhooksErr)
+		return
+	}
+	vars := mux.Vars(r)
+	attempts, err := webhooksmgr.EntityAttempts(env.ID, vars["entity"], vars["id"])
+	if err != nil {
+		apiErrorResponse(w, "error getting attempts", http.StatusInternalServerError, err)
+		incMetric(metricAPIWebhooksErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned webhook attempts for %s %s", vars["entity"], vars["id"])
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, attempts)
+	incMetric(metricAPIWebhooksOK)
+}
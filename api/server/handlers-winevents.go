@@ -0,0 +1,198 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	metricAPIWinEventsReq = "winevents-req"
+	metricAPIWinEventsErr = "winevents-err"
+	metricAPIWinEventsOK  = "winevents-ok"
+)
+
+// GET Handler to return the Windows event channels of one environment as JSON
+func apiWindowsEventsHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIWinEventsReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, _, ok := envWithAccess(w, r, users.UserLevel)
+	if !ok {
+		incMetric(metricAPIWinEventsErr)
+		return
+	}
+	channels, err := envs.WindowsEventsCtx(r.Context(), env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting windows event channels", http.StatusInternalServerError, err)
+		incMetric(metricAPIWinEventsErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Returned windows event channels for %s", env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, environments.PreviewWindowsEvents(channels))
+	incMetric(metricAPIWinEventsOK)
+}
+
+// POST Handler to create or update a Windows event channel of one environment
+func apiSetWindowsEventHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIWinEventsReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, actor, ok := envWithAccess(w, r, users.AdminLevel)
+	if !ok {
+		incMetric(metricAPIWinEventsErr)
+		return
+	}
+	var c types.ApiWindowsEventRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPIWinEventsErr)
+		return
+	}
+	before, err := envs.WindowsEventsCtx(r.Context(), env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting windows event channels", http.StatusInternalServerError, err)
+		incMetric(metricAPIWinEventsErr)
+		return
+	}
+	channel, err := envs.SetWindowsEventChannel(env.ID, environments.WindowsEventChannel{
+		Channel:  c.Channel,
+		Enabled:  c.Enabled,
+		Interval: c.Interval,
+		MaxRows:  c.MaxRows,
+	})
+	if err != nil {
+		apiErrorResponse(w, "error saving windows event channel", http.StatusBadRequest, err)
+		incMetric(metricAPIWinEventsErr)
+		return
+	}
+	after, err := envs.WindowsEventsCtx(r.Context(), env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting windows event channels", http.StatusInternalServerError, err)
+		incMetric(metricAPIWinEventsErr)
+		return
+	}
+	recordActivity(env, environments.ActivityWindowsEvents, actor, "windows event channel "+channel.Channel+" saved", environments.SerializeWindowsEvents(before), environments.SerializeWindowsEvents(after))
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Saved windows event channel %s for %s", channel.Channel, env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, environments.PreviewWindowsEvents(after))
+	incMetric(metricAPIWinEventsOK)
+}
+
+// POST Handler to delete a Windows event channel of one environment
+func apiDeleteWindowsEventHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIWinEventsReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, actor, ok := envWithAccess(w, r, users.AdminLevel)
+	if !ok {
+		incMetric(metricAPIWinEventsErr)
+		return
+	}
+	var c types.ApiWindowsEventRequest
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPIWinEventsErr)
+		return
+	}
+	before, err := envs.WindowsEventsCtx(r.Context(), env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting windows event channels", http.StatusInternalServerError, err)
+		incMetric(metricAPIWinEventsErr)
+		return
+	}
+	if err := envs.DeleteWindowsEventChannel(env.ID, c.Channel); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			apiErrorResponse(w, "windows event channel not found", http.StatusNotFound, err)
+		} else {
+			apiErrorResponse(w, "error deleting windows event channel", http.StatusInternalServerError, err)
+		}
+		incMetric(metricAPIWinEventsErr)
+		return
+	}
+	after, err := envs.WindowsEventsCtx(r.Context(), env.ID)
+	if err != nil {
+		log.Printf("error getting windows event channels %v", err)
+	}
+	// Return message as serialized response
+	msg := fmt.Sprintf("windows event channel %s deleted", c.Channel)
+	recordActivity(env, environments.ActivityWindowsEvents, actor, msg, environments.SerializeWindowsEvents(before), environments.SerializeWindowsEvents(after))
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: %s for %s", msg, env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, types.ApiGenericResponse{Message: msg})
+	incMetric(metricAPIWinEventsOK)
+}
+
+// GET Handler to export the Windows event channels of one environment, to import them in another environment
+func apiExportWindowsEventsHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIWinEventsReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, _, ok := envWithAccess(w, r, users.UserLevel)
+	if !ok {
+		incMetric(metricAPIWinEventsErr)
+		return
+	}
+	channels, err := envs.WindowsEventsCtx(r.Context(), env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting windows event channels", http.StatusInternalServerError, err)
+		incMetric(metricAPIWinEventsErr)
+		return
+	}
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: Exported windows event channels for %s", env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, environments.ExportWindowsEvents(channels))
+	incMetric(metricAPIWinEventsOK)
+}
+
+// POST Handler to replace all the Windows event channels of one environment with exported ones
+func apiImportWindowsEventsHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricAPIWinEventsReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), false)
+	env, actor, ok := envWithAccess(w, r, users.AdminLevel)
+	if !ok {
+		incMetric(metricAPIWinEventsErr)
+		return
+	}
+	var imported []environments.WindowsEventSetting
+	// Parse request JSON body
+	if err := json.NewDecoder(r.Body).Decode(&imported); err != nil {
+		apiErrorResponse(w, "error parsing POST body", http.StatusInternalServerError, err)
+		incMetric(metricAPIWinEventsErr)
+		return
+	}
+	before, err := envs.WindowsEventsCtx(r.Context(), env.ID)
+	if err != nil {
+		apiErrorResponse(w, "error getting windows event channels", http.StatusInternalServerError, err)
+		incMetric(metricAPIWinEventsErr)
+		return
+	}
+	channels, err := envs.ImportWindowsEvents(env.ID, imported)
+	if err != nil {
+		apiErrorResponse(w, "error importing windows event channels", http.StatusBadRequest, err)
+		incMetric(metricAPIWinEventsErr)
+		return
+	}
+	msg := fmt.Sprintf("%d windows event channels imported", len(channels))
+	recordActivity(env, environments.ActivityWindowsEvents, actor, msg, environments.SerializeWindowsEvents(before), environments.SerializeWindowsEvents(channels))
+	// Serialize and serve JSON
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Printf("DebugService: %s for %s", msg, env.Name)
+	}
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, environments.PreviewWindowsEvents(channels))
+	incMetric(metricAPIWinEventsOK)
+}
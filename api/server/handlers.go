@@ -1,10 +1,11 @@
-package main
+package server
 
 import (
 	"net/http"
 
 	"github.com/jmpsec/osctrl/settings"
 	"github.com/jmpsec/osctrl/utils"
+	"github.com/jmpsec/osctrl/version"
 )
 
 const (
@@ -27,6 +28,15 @@ func healthHTTPHandler(w http.ResponseWriter, r *http.Request) {
 	incMetric(metricHealthOK)
 }
 
+// Handle version requests, with the build of the service and without configuration details
+func versionHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	incMetric(metricHealthReq)
+	utils.DebugHTTPDump(r, settingsmgr.DebugHTTP(settings.ServiceAPI), true)
+	// Send response
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, version.Info(serviceName))
+	incMetric(metricHealthOK)
+}
+
 // Handle root requests
 func rootHTTPHandler(w http.ResponseWriter, r *http.Request) {
 	incMetric(metricHealthReq)
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmpsec/osctrl/cache"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+const (
+	// Header with the key sent by clients to make a request idempotent
+	idempotencyHeader = "Idempotency-Key"
+	// Header added to responses that are replayed from a previous request
+	idempotencyReplayed = "Idempotent-Replayed"
+	// Maximum length of an idempotency key
+	idempotencyKeyMax = 255
+	// Time that a key stays locked while the request is in progress
+	idempotencyLock = 5 * time.Minute
+)
+
+// Methods that can use idempotency keys
+var idempotentMethods = map[string]bool{
+	http.MethodPost:   true,
+	http.MethodPut:    true,
+	http.MethodPatch:  true,
+	http.MethodDelete: true,
+}
+
+// idempotencyStore to keep the state of the requests with idempotency keys
+type idempotencyStore interface {
+	ClaimIdempotency(key, fingerprint string, lock time.Duration) (cache.IdempotencyRecord, bool, error)
+	CompleteIdempotency(key string, record cache.IdempotencyRecord, expire time.Duration) error
+	ReleaseIdempotency(key string) error
+}
+
+// idempotencyRecorder to capture the response of a request, so it can be stored before it is sent
+type idempotencyRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+// Header to implement http.ResponseWriter
+func (rec *idempotencyRecorder) Header() http.Header {
+	return rec.header
+}
+
+// Write to implement http.ResponseWriter
+func (rec *idempotencyRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.body.Write(b)
+}
+
+// WriteHeader to implement http.ResponseWriter
+func (rec *idempotencyRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+// Helper to get the time to keep the responses of idempotent requests
+func idempotencyExpiration() time.Duration {
+	hours := int64(cache.IdempotencyHours)
+	if settingsmgr != nil {
+		if h := settingsmgr.IdempotencyHours(); h > 0 {
+			hours = h
+		}
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// Helper to generate the stored key, scoped to the service key or user that sends the request
+func idempotencyKey(ctx contextValue, key string) string {
+	owner := ctx[ctxUser]
+	if ctx[ctxKeyID] != "" {
+		owner = ctx[ctxKeyID]
+	}
+	hash := sha256.Sum256([]byte(owner + "\x00" + key))
+	return hex.EncodeToString(hash[:])
+}
+
+// Helper to generate the fingerprint of a request, to detect keys reused with a different request
+func idempotencyFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.Path + "\x00"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Helper to send a stored response again
+func idempotencyReplay(w http.ResponseWriter, record cache.IdempotencyRecord) {
+	if record.ContentType != "" {
+		w.Header().Set(utils.ContentType, record.ContentType)
+	}
+	w.Header().Set(idempotencyReplayed, "true")
+	w.WriteHeader(record.Status)
+	if _, err := w.Write(record.Body); err != nil {
+		log.Printf("error replaying response %v", err)
+	}
+}
+
+// Handler to make requests with an idempotency key execute only once, duplicates get the stored response
+func handlerIdempotency(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyHeader)
+		if key == "" || idempotency == nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > idempotencyKeyMax {
+			apiErrorResponse(w, "invalid idempotency key", http.StatusBadRequest, nil)
+			incMetric(metricAPIErr)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			apiErrorResponse(w, "error reading body", http.StatusBadRequest, err)
+			incMetric(metricAPIErr)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+		stored := idempotencyKey(ctx, key)
+		fingerprint := idempotencyFingerprint(r, body)
+		record, claimed, err := idempotency.ClaimIdempotency(stored, fingerprint, idempotencyLock)
+		if err != nil {
+			apiErrorResponse(w, "error checking idempotency key", http.StatusInternalServerError, err)
+			incMetric(metricAPIErr)
+			return
+		}
+		if !claimed {
+			if record.Fingerprint != fingerprint {
+				apiErrorResponse(w, "idempotency key used with a different request", http.StatusConflict, nil)
+				incMetric(metricAPIErr)
+				return
+			}
+			if !record.Done {
+				w.Header().Set("Retry-After", strconv.Itoa(int(idempotencyLock.Seconds())))
+				apiErrorResponse(w, "request with the same idempotency key in progress", http.StatusConflict, nil)
+				incMetric(metricAPIErr)
+				return
+			}
+			idempotencyReplay(w, record)
+			return
+		}
+		rec := &idempotencyRecorder{header: w.Header()}
+		h.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		// Server errors are not stored so the request can be retried
+		if rec.status >= http.StatusInternalServerError {
+			if err := idempotency.ReleaseIdempotency(stored); err != nil {
+				log.Printf("error releasing idempotency key %v", err)
+			}
+		} else {
+			record.Status = rec.status
+			record.ContentType = rec.header.Get(utils.ContentType)
+			record.Body = rec.body.Bytes()
+			if err := idempotency.CompleteIdempotency(stored, record, idempotencyExpiration()); err != nil {
+				log.Printf("error storing idempotent response %v", err)
+			}
+		}
+		w.WriteHeader(rec.status)
+		if _, err := w.Write(rec.body.Bytes()); err != nil {
+			log.Printf("error sending response %v", err)
+		}
+	})
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jmpsec/osctrl/cache"
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryIdempotency to keep idempotent requests in memory for tests
+type memoryIdempotency struct {
+	mutex   sync.Mutex
+	records map[string]cache.IdempotencyRecord
+}
+
+func (m *memoryIdempotency) ClaimIdempotency(key, fingerprint string, lock time.Duration) (cache.IdempotencyRecord, bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if existing, ok := m.records[key]; ok {
+		return existing, false, nil
+	}
+	record := cache.IdempotencyRecord{Fingerprint: fingerprint}
+	m.records[key] = record
+	return record, true, nil
+}
+
+func (m *memoryIdempotency) CompleteIdempotency(key string, record cache.IdempotencyRecord, expire time.Duration) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	record.Done = true
+	m.records[key] = record
+	return nil
+}
+
+func (m *memoryIdempotency) ReleaseIdempotency(key string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.records, key)
+	return nil
+}
+
+func useMemoryIdempotency(t *testing.T) {
+	idempotency = &memoryIdempotency{records: make(map[string]cache.IdempotencyRecord)}
+	t.Cleanup(func() { idempotency = nil })
+}
+
+func idempotentRequest(h http.Handler, user, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/queries/dev", strings.NewReader(body))
+	req.Header.Set(idempotencyHeader, key)
+	req = req.WithContext(context.WithValue(req.Context(), contextKey(contextAPI), contextValue{ctxUser: user}))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestIdempotencyReplay(t *testing.T) {
+	useMemoryIdempotency(t)
+	var executed int32
+	h := handlerIdempotency(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&executed, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"run":` + strconv.Itoa(int(n)) + `}`))
+	}))
+	first := idempotentRequest(h, "admin", "abc", `{"query":"select 1"}`)
+	assert.Equal(t, http.StatusOK, first.Code)
+	assert.Equal(t, `{"run":1}`, first.Body.String())
+	// Same key and body gets the stored response
+	retry := idempotentRequest(h, "admin", "abc", `{"query":"select 1"}`)
+	assert.Equal(t, http.StatusOK, retry.Code)
+	assert.Equal(t, `{"run":1}`, retry.Body.String())
+	assert.Equal(t, "true", retry.Header().Get(idempotencyReplayed))
+	assert.Equal(t, "application/json", retry.Header().Get("Content-Type"))
+	// Same key with a different body is a conflict
+	other := idempotentRequest(h, "admin", "abc", `{"query":"select 2"}`)
+	assert.Equal(t, http.StatusConflict, other.Code)
+	// Keys are scoped by user
+	user := idempotentRequest(h, "analyst", "abc", `{"query":"select 1"}`)
+	assert.Equal(t, http.StatusOK, user.Code)
+	assert.Equal(t, `{"run":2}`, user.Body.String())
+	assert.Equal(t, int32(2), atomic.LoadInt32(&executed))
+	// Requests without key are not tracked
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/v1/queries/dev", nil))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&executed))
+	// Keys too long are rejected
+	long := idempotentRequest(h, "admin", strings.Repeat("k", idempotencyKeyMax+1), `{}`)
+	assert.Equal(t, http.StatusBadRequest, long.Code)
+}
+
+func TestIdempotencyServerError(t *testing.T) {
+	useMemoryIdempotency(t)
+	var executed int32
+	h := handlerIdempotency(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&executed, 1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	assert.Equal(t, http.StatusInternalServerError, idempotentRequest(h, "admin", "abc", `{}`).Code)
+	// Failed requests release the key and can be retried
+	assert.Equal(t, http.StatusOK, idempotentRequest(h, "admin", "abc", `{}`).Code)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&executed))
+}
+
+func TestIdempotencyRace(t *testing.T) {
+	useMemoryIdempotency(t)
+	var executed int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+	h := handlerIdempotency(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&executed, 1)
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("done"))
+	}))
+	original := make(chan *httptest.ResponseRecorder)
+	go func() {
+		original <- idempotentRequest(h, "admin", "abc", `{}`)
+	}()
+	<-started
+	// Retries while the original is running do not execute again
+	var wg sync.WaitGroup
+	codes := make([]int, 10)
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rr := idempotentRequest(h, "admin", "abc", `{}`)
+			codes[i] = rr.Code
+			assert.NotEmpty(t, rr.Header().Get("Retry-After"))
+		}(i)
+	}
+	wg.Wait()
+	for _, code := range codes {
+		assert.Equal(t, http.StatusConflict, code)
+	}
+	close(release)
+	first := <-original
+	assert.Equal(t, http.StatusOK, first.Code)
+	assert.Equal(t, "done", first.Body.String())
+	// Once completed, retries get the stored response
+	retry := idempotentRequest(h, "admin", "abc", `{}`)
+	assert.Equal(t, http.StatusOK, retry.Code)
+	assert.Equal(t, "done", retry.Body.String())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&executed))
+}
+
+func TestIdempotencyOpenAPI(t *testing.T) {
+	doc := buildOpenAPI(apiRoutes())
+	for _, route := range apiRoutes() {
+		op := doc.Paths[_apiPath(route.Path)][strings.ToLower(route.Method)]
+		_, conflict := op.Responses["409"]
+		assert.Equal(t, !route.Public && idempotentMethods[route.Method], conflict, "route %s %s", route.Method, route.Path)
+	}
+}
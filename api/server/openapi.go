@@ -0,0 +1,327 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	// Version of the OpenAPI specification for the generated document
+	openAPIVersion = "3.0.3"
+	// Name of the security scheme for API tokens
+	openAPISecurity = "bearerAuth"
+	// Prefix for references to schemas
+	openAPISchemaRef = "#/components/schemas/"
+)
+
+// openAPISchema to hold a JSON schema, kept generic to cover every type
+type openAPISchema map[string]interface{}
+
+// OpenAPIDoc to hold the OpenAPI document of the API
+type OpenAPIDoc struct {
+	OpenAPI    string                                 `json:"openapi"`
+	Info       OpenAPIInfo                            `json:"info"`
+	Paths      map[string]map[string]OpenAPIOperation `json:"paths"`
+	Components OpenAPIComponents                      `json:"components"`
+	Security   []map[string][]string                  `json:"security"`
+}
+
+// OpenAPIInfo to hold the metadata of the API
+type OpenAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// OpenAPIOperation to hold one method of a path
+type OpenAPIOperation struct {
+	OperationID string                     `json:"operationId"`
+	Summary     string                     `json:"summary"`
+	Description string                     `json:"description,omitempty"`
+	Tags        []string                   `json:"tags"`
+	Parameters  []OpenAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *OpenAPIBody               `json:"requestBody,omitempty"`
+	Responses   map[string]OpenAPIResponse `json:"responses"`
+	Security    *[]map[string][]string     `json:"security,omitempty"`
+}
+
+// OpenAPIParameter to hold a variable of the path or a header
+type OpenAPIParameter struct {
+	Name        string        `json:"name"`
+	In          string        `json:"in"`
+	Description string        `json:"description,omitempty"`
+	Required    bool          `json:"required"`
+	Schema      openAPISchema `json:"schema"`
+}
+
+// OpenAPIBody to hold the content of a request
+type OpenAPIBody struct {
+	Required bool                        `json:"required"`
+	Content  map[string]OpenAPIMediaType `json:"content"`
+}
+
+// OpenAPIResponse to hold one response of an operation
+type OpenAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]OpenAPIMediaType `json:"content,omitempty"`
+}
+
+// OpenAPIMediaType to hold the schema of a content type
+type OpenAPIMediaType struct {
+	Schema openAPISchema `json:"schema"`
+}
+
+// OpenAPIComponents to hold the schemas and security schemes referenced by the operations
+type OpenAPIComponents struct {
+	Schemas         map[string]openAPISchema `json:"schemas"`
+	SecuritySchemes map[string]openAPISchema `json:"securitySchemes"`
+}
+
+var (
+	// Variables in the paths of routes
+	pathVarsRegexp = regexp.MustCompile(`{([^}]+)}`)
+	// Generated once, routes do not change while running
+	openAPIOnce sync.Once
+	openAPIDoc  OpenAPIDoc
+)
+
+// Errors that any operation can return, all using the error envelope
+var openAPIErrors = map[string]string{
+	"400": "Invalid request",
+	"403": "No access",
+	"404": "Not found",
+	"500": "Internal error",
+}
+
+// schemaBuilder to generate schemas from Go types, named structs are added as components
+type schemaBuilder struct {
+	schemas map[string]openAPISchema
+}
+
+// Helper to get the component name of a named type, like nodes.OsqueryNode
+func schemaName(t reflect.Type) string {
+	pkg := t.PkgPath()
+	if i := strings.LastIndex(pkg, "/"); i >= 0 {
+		pkg = pkg[i+1:]
+	}
+	if pkg == "" {
+		return t.Name()
+	}
+	return pkg + "." + t.Name()
+}
+
+// Schema to generate the schema for a type
+func (b *schemaBuilder) Schema(t reflect.Type) openAPISchema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t {
+	case reflect.TypeOf(time.Time{}):
+		return openAPISchema{"type": "string", "format": "date-time"}
+	case reflect.TypeOf(gorm.DeletedAt{}):
+		return openAPISchema{"type": "string", "format": "date-time", "nullable": true}
+	case reflect.TypeOf(json.RawMessage{}):
+		return openAPISchema{}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return openAPISchema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32:
+		return openAPISchema{"type": "integer", "format": "int32"}
+	case reflect.Int64:
+		return openAPISchema{"type": "integer", "format": "int64"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return openAPISchema{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return openAPISchema{"type": "number"}
+	case reflect.String:
+		return openAPISchema{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return openAPISchema{"type": "string", "format": "byte"}
+		}
+		return openAPISchema{"type": "array", "items": b.Schema(t.Elem())}
+	case reflect.Map:
+		return openAPISchema{"type": "object", "additionalProperties": b.Schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.structSchema(t)
+		}
+		name := schemaName(t)
+		if _, ok := b.schemas[name]; !ok {
+			// Placeholder first, so types that reference themselves do not recurse forever
+			b.schemas[name] = openAPISchema{}
+			b.schemas[name] = b.structSchema(t)
+		}
+		return openAPISchema{"$ref": openAPISchemaRef + name}
+	}
+	// Interfaces and anything else can hold any value
+	return openAPISchema{}
+}
+
+// Helper to generate the schema of a struct, following the encoding/json rules for field names
+func (b *schemaBuilder) structSchema(t reflect.Type) openAPISchema {
+	properties := make(map[string]interface{})
+	b.addFields(t, properties)
+	return openAPISchema{"type": "object", "properties": properties}
+}
+
+// Helper to add the fields of a struct to the properties, embedded structs are flattened
+func (b *schemaBuilder) addFields(t reflect.Type, properties map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		opts := strings.Split(tag, ",")
+		name := opts[0]
+		ft := f.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			b.addFields(ft, properties)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		schema := b.Schema(f.Type)
+		for _, o := range opts[1:] {
+			if o == "string" {
+				schema = openAPISchema{"type": "string"}
+			}
+		}
+		properties[name] = schema
+	}
+}
+
+// Helper to get a stable id for an operation, from the name of the handler
+func operationID(h http.HandlerFunc) string {
+	name := runtime.FuncForPC(reflect.ValueOf(h).Pointer()).Name()
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
+
+// buildOpenAPI to generate the OpenAPI document for the routes
+func buildOpenAPI(routes []apiRoute) OpenAPIDoc {
+	b := &schemaBuilder{schemas: make(map[string]openAPISchema)}
+	errorSchema := b.Schema(reflect.TypeOf(types.ApiErrorResponse{}))
+	doc := OpenAPIDoc{
+		OpenAPI: openAPIVersion,
+		Info: OpenAPIInfo{
+			Title:   serviceName,
+			Version: serviceVersion,
+		},
+		Paths: make(map[string]map[string]OpenAPIOperation),
+		Components: OpenAPIComponents{
+			SecuritySchemes: map[string]openAPISchema{
+				openAPISecurity: {"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+			},
+		},
+		Security: []map[string][]string{{openAPISecurity: {}}},
+	}
+	ids := make(map[string]int)
+	for _, route := range routes {
+		op := OpenAPIOperation{
+			OperationID: operationID(route.Handler),
+			Summary:     route.Summary,
+			Tags:        []string{route.Tag},
+			Responses:   make(map[string]OpenAPIResponse),
+		}
+		if route.Scope != "" {
+			op.Description = "Service keys need the scope " + route.Scope
+		}
+		// Handlers used in more than one route still need unique ids
+		ids[op.OperationID]++
+		if n := ids[op.OperationID]; n > 1 {
+			op.OperationID += strconv.Itoa(n)
+		}
+		for _, v := range pathVarsRegexp.FindAllStringSubmatch(route.Path, -1) {
+			op.Parameters = append(op.Parameters, OpenAPIParameter{
+				Name:     v[1],
+				In:       "path",
+				Required: true,
+				Schema:   openAPISchema{"type": "string"},
+			})
+		}
+		if route.Request != nil {
+			op.RequestBody = &OpenAPIBody{
+				Required: true,
+				Content: map[string]OpenAPIMediaType{
+					utils.JSONApplication: {Schema: b.Schema(reflect.TypeOf(route.Request))},
+				},
+			}
+		}
+		if route.Response != nil {
+			op.Responses["200"] = OpenAPIResponse{
+				Description: "OK",
+				Content: map[string]OpenAPIMediaType{
+					utils.JSONApplication: {Schema: b.Schema(reflect.TypeOf(route.Response))},
+				},
+			}
+		} else {
+			op.Responses["200"] = OpenAPIResponse{
+				Description: "OK",
+				Content: map[string]OpenAPIMediaType{
+					route.ContentType: {Schema: openAPISchema{"type": "string", "format": "binary"}},
+				},
+			}
+		}
+		for code, desc := range openAPIErrors {
+			op.Responses[code] = OpenAPIResponse{
+				Description: desc,
+				Content: map[string]OpenAPIMediaType{
+					utils.JSONApplication: {Schema: errorSchema},
+				},
+			}
+		}
+		if route.Public {
+			op.Security = &[]map[string][]string{}
+		} else if idempotentMethods[route.Method] {
+			op.Parameters = append(op.Parameters, OpenAPIParameter{
+				Name:        idempotencyHeader,
+				In:          "header",
+				Description: "Key to retry the request safely, the response is stored and replayed for the same key and body",
+				Schema:      openAPISchema{"type": "string", "maxLength": idempotencyKeyMax},
+			})
+			op.Responses["409"] = OpenAPIResponse{
+				Description: "Idempotency key in progress or used with a different request",
+				Content: map[string]OpenAPIMediaType{
+					utils.JSONApplication: {Schema: errorSchema},
+				},
+			}
+		}
+		path := _apiPath(route.Path)
+		if _, ok := doc.Paths[path]; !ok {
+			doc.Paths[path] = make(map[string]OpenAPIOperation)
+		}
+		doc.Paths[path][strings.ToLower(route.Method)] = op
+	}
+	doc.Components.Schemas = b.schemas
+	return doc
+}
+
+// GET Handler for the OpenAPI spec of the API
+func apiOpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	openAPIOnce.Do(func() {
+		openAPIDoc = buildOpenAPI(apiRoutes())
+	})
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, openAPIDoc)
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenAPIRoutes(t *testing.T) {
+	doc := buildOpenAPI(apiRoutes())
+	router := createRouterAPI()
+	checked := 0
+	// Every route registered under the API prefix must be in the spec
+	err := router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil || !strings.HasPrefix(path, _apiPath("")) {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if !assert.NoError(t, err, "route %s without methods", path) {
+			return nil
+		}
+		if len(path) > 1 {
+			path = strings.TrimSuffix(path, "/")
+		}
+		for _, m := range methods {
+			_, ok := doc.Paths[path][strings.ToLower(m)]
+			assert.True(t, ok, "route %s %s is missing in the OpenAPI spec", m, path)
+			checked++
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2*len(apiRoutes()), checked)
+}
+
+func TestOpenAPIMetadata(t *testing.T) {
+	doc := buildOpenAPI(apiRoutes())
+	ids := make(map[string]bool)
+	for _, route := range apiRoutes() {
+		assert.NotEmpty(t, route.Summary, "route %s without summary", route.Path)
+		assert.NotEmpty(t, route.Tag, "route %s without tag", route.Path)
+		assert.True(t, route.Response != nil || route.ContentType != "", "route %s without response", route.Path)
+		op := doc.Paths[_apiPath(route.Path)][strings.ToLower(route.Method)]
+		assert.False(t, ids[op.OperationID], "duplicated operation id %s", op.OperationID)
+		ids[op.OperationID] = true
+	}
+	// All references must point to a generated schema
+	raw, err := json.Marshal(doc)
+	assert.NoError(t, err)
+	for _, ref := range strings.Split(string(raw), `"$ref":"`)[1:] {
+		name := strings.TrimPrefix(ref[:strings.Index(ref, `"`)], openAPISchemaRef)
+		_, ok := doc.Components.Schemas[name]
+		assert.True(t, ok, "missing schema %s", name)
+	}
+	node, ok := doc.Components.Schemas["nodes.OsqueryNode"]
+	assert.True(t, ok)
+	properties := node["properties"].(map[string]interface{})
+	// Fields from the embedded gorm.Model are flattened
+	assert.Contains(t, properties, "ID")
+	assert.Contains(t, properties, "UUID")
+}
+
+func TestOpenAPIHandler(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, _apiPath(apiOpenAPIPath), nil)
+	rr := httptest.NewRecorder()
+	createRouterAPI().ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var doc map[string]interface{}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &doc))
+	assert.Equal(t, openAPIVersion, doc["openapi"])
+	assert.Contains(t, doc["paths"], _apiPath(apiNodesPath)+"/{env}/node/{node}")
+}
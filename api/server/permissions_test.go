@@ -0,0 +1,44 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmpsec/osctrl/queries"
+	"github.com/jmpsec/osctrl/responses"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestCarveAccess(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db, got error: %v", err)
+	}
+	defer mockDB.Close()
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: mockDB}), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("unable to create new postgres database: %v", err)
+	}
+	previous := apiUsers
+	apiUsers = &users.UserManager{DB: db}
+	defer func() { apiUsers = previous }()
+	ctx := contextValue{ctxUser: "alice"}
+	// User with query access but without carve access
+	access := func(level users.AccessLevel) bool {
+		mock.ExpectQuery(`SELECT count\(\*\) FROM "admin_users"`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectQuery(`SELECT \* FROM "user_permissions"`).WillReturnRows(
+			sqlmock.NewRows([]string{"username", "access_type", "access_value", "environment"}).
+				AddRow("alice", users.UserLevel, true, "dev").
+				AddRow("alice", users.QueryLevel, true, "dev").
+				AddRow("alice", users.CarveLevel, false, "dev"))
+		return checkAccess(ctx, level, "dev")
+	}
+	assert.True(t, access(quickActionLevel(queries.QuickActionQuery)))
+	assert.False(t, access(quickActionLevel(queries.QuickActionCarve)))
+	assert.True(t, access(responseActionLevel(responses.KindQuery)))
+	assert.False(t, access(responseActionLevel(responses.KindCarve)))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
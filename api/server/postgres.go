@@ -0,0 +1,120 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/logging"
+	"github.com/jmpsec/osctrl/queries"
+	"github.com/jmpsec/osctrl/types"
+	"gorm.io/gorm"
+)
+
+// Number of query logs read at once to export results
+const queryLogsBatch = 100
+
+// OsqueryQueryData to log query data to database
+type OsqueryQueryData struct {
+	gorm.Model
+	UUID        string `gorm:"index"`
+	Environment string
+	Name        string
+	Data        json.RawMessage
+	Status      int
+}
+
+// APIQueryData to return query results from API
+type APIQueryData map[string]json.RawMessage
+
+// APIQueryStatus to return a query from API with the schema inferred from its results
+type APIQueryStatus struct {
+	queries.DistributedQuery
+	Schema queries.ResultSchema `json:"schema"`
+}
+
+// APIQueryResults to return query results from API with the investigation context of the query
+type APIQueryResults struct {
+	Name    string               `json:"name"`
+	Context queries.QueryContext `json:"context"`
+	Results APIQueryData         `json:"results"`
+}
+
+// Function to retrieve the query log by name
+func postgresQueryLogs(name string) (APIQueryData, error) {
+	var logs []OsqueryQueryData
+	data := make(APIQueryData)
+	if err := db.Conn.Where("name = ?", name).Find(&logs).Error; err != nil {
+		return data, err
+	}
+	for _, l := range logs {
+		data[l.UUID] = l.Data
+	}
+	return data, nil
+}
+
+// Function to iterate the query logs of a query by name in batches, so large results are not loaded at once
+func postgresQueryLogsBatches(name string, fn func(OsqueryQueryData) error) error {
+	var logs []OsqueryQueryData
+	return db.Conn.Where("name = ?", name).Order("id").FindInBatches(&logs, queryLogsBatch, func(tx *gorm.DB, batch int) error {
+		for _, l := range logs {
+			if err := fn(l); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+}
+
+// Function to iterate the rows of the results of a query by name, with the node and the time they were collected
+func postgresQueryRows(name string, fn func(node string, collected time.Time, row map[string]string) error) error {
+	return postgresQueryLogsBatches(name, func(l OsqueryQueryData) error {
+		var data types.QueryWriteData
+		if err := json.Unmarshal(l.Data, &data); err != nil {
+			log.Printf("error with query log %d - %v", l.ID, err)
+			return nil
+		}
+		rows, err := queries.ParseResultRows(data.Result)
+		if err != nil {
+			log.Printf("error with query log %d - %v", l.ID, err)
+			return nil
+		}
+		for _, row := range rows {
+			if err := fn(l.UUID, l.CreatedAt, row); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Function to infer the schema of the results of a query by name, as they are exported for its environment
+func postgresQuerySchema(env environments.TLSEnvironment, name string) (queries.ResultSchema, error) {
+	inference := queries.NewSchemaInference()
+	err := envQueryRows(env)(name, func(node string, collected time.Time, row map[string]string) error {
+		inference.Add(row)
+		return nil
+	})
+	return inference.Schema(), err
+}
+
+// Function to retrieve result logs, oldest first
+func postgresResultLogs(filter logging.ResultFilter) ([]logging.OsqueryResultData, error) {
+	logDB := logging.LoggerDB{Database: db, Enabled: true}
+	return logDB.FilterResultLogs(filter)
+}
+
+// Helper to convert result logs to events, entries with invalid columns are skipped
+func resultEvents(logs []logging.OsqueryResultData) []types.ResultEvent {
+	events := []types.ResultEvent{}
+	for _, l := range logs {
+		e, err := l.Event()
+		if err != nil {
+			log.Printf("error with result log %d - %v", l.ID, err)
+			continue
+		}
+		events = append(events, e)
+	}
+	return events
+}
@@ -0,0 +1,213 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jmpsec/osctrl/cache"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+const (
+	metricAPICacheHit    = "api-cache-hit"
+	metricAPICacheMiss   = "api-cache-miss"
+	metricAPICacheBypass = "api-cache-bypass"
+	metricAPINotModified = "api-not-modified"
+)
+
+const (
+	// Header added to responses with how they were served from the cache
+	responseCacheHeader = "X-Cache"
+)
+
+// Groups of cached responses invalidated by changes to routes of each tag
+var responseGroups = map[string]string{
+	tagNodes:        cache.ResponseGroupNodes,
+	tagEnvironments: cache.ResponseGroupEnvironments,
+}
+
+// responseStore to keep the responses of hot read routes for a short time
+type responseStore interface {
+	ResponseGeneration(group string) (int64, error)
+	GetResponse(group string, generation int64, hash string) (cache.CachedResponse, bool, error)
+	SetResponse(group string, generation int64, hash string, response cache.CachedResponse, expire time.Duration) error
+	InvalidateResponses(group string) error
+}
+
+// Helper to generate the fingerprint of the permissions of the service key or user that sends the request.
+// Cached responses are only shared by requests with the same fingerprint.
+func responseScope(ctx contextValue) (string, error) {
+	if ctx[ctxKeyID] != "" {
+		return "key\x00" + ctx[ctxKeyID] + "\x00" + ctx[ctxKeyEnv] + "\x00" + ctx[ctxKeyScopes], nil
+	}
+	access, err := apiUsers.GetAccess(ctx[ctxUser])
+	if err != nil {
+		return "", err
+	}
+	// Maps are serialized with sorted keys, so the same permissions get the same fingerprint
+	serialized, err := json.Marshal(access)
+	if err != nil {
+		return "", err
+	}
+	admin := "0"
+	if apiUsers.IsAdmin(ctx[ctxUser]) {
+		admin = "1"
+	}
+	return "user\x00" + ctx[ctxUser] + "\x00" + admin + "\x00" + string(serialized), nil
+}
+
+// Helper to generate the hash of a cached response, from the path, the query parameters and the permissions
+func responseHash(r *http.Request, scope string) string {
+	hash := sha256.Sum256([]byte(r.URL.Path + "\x00" + r.URL.Query().Encode() + "\x00" + scope))
+	return hex.EncodeToString(hash[:])
+}
+
+// Helper to generate the ETag of a response body
+func bodyETag(body []byte) string {
+	hash := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(hash[:16]) + `"`
+}
+
+// Helper to check if an ETag is in the If-None-Match header of a request, with weak comparison
+func etagMatch(header, etag string) bool {
+	if header == "" || etag == "" {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper to set the ETag of a response and reply with 304 when the client already has it
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	if !etagMatch(r.Header.Get("If-None-Match"), etag) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	incMetric(metricAPINotModified)
+	return true
+}
+
+// Helper to check if a request asks to skip cached responses
+func bypassCache(r *http.Request) bool {
+	for _, directive := range strings.Split(r.Header.Get("Cache-Control"), ",") {
+		switch strings.ToLower(strings.TrimSpace(directive)) {
+		case "no-cache", "no-store":
+			return true
+		}
+	}
+	return false
+}
+
+// Helper to send a cached response, or 304 when the client already has it
+func responseReplay(w http.ResponseWriter, r *http.Request, response cache.CachedResponse, status string) {
+	w.Header().Set(responseCacheHeader, status)
+	if notModified(w, r, bodyETag(response.Body)) {
+		return
+	}
+	if response.ContentType != "" {
+		w.Header().Set(utils.ContentType, response.ContentType)
+	}
+	w.WriteHeader(response.Status)
+	if _, err := w.Write(response.Body); err != nil {
+		log.Printf("error sending response %v", err)
+	}
+}
+
+// Handler to serve the responses of a read route from the cache for a short time, by permissions of the caller
+func handlerResponseCache(h http.Handler, group string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if readCache == nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		ctx := r.Context().Value(contextKey(contextAPI)).(contextValue)
+		scope, err := responseScope(ctx)
+		if err != nil {
+			log.Printf("error getting permissions for cached response %v", err)
+			h.ServeHTTP(w, r)
+			return
+		}
+		hash := responseHash(r, scope)
+		// The generation is read before the request, so responses of a group invalidated meanwhile are not kept
+		generation, err := readCache.ResponseGeneration(group)
+		if err != nil {
+			log.Printf("error getting generation of cached responses %v", err)
+			h.ServeHTTP(w, r)
+			return
+		}
+		status := "MISS"
+		if bypassCache(r) {
+			status = "BYPASS"
+			incMetric(metricAPICacheBypass)
+		} else {
+			cached, ok, err := readCache.GetResponse(group, generation, hash)
+			if err != nil {
+				log.Printf("error getting cached response %v", err)
+			}
+			if ok {
+				incMetric(metricAPICacheHit)
+				responseReplay(w, r, cached, "HIT")
+				return
+			}
+			incMetric(metricAPICacheMiss)
+		}
+		rec := &idempotencyRecorder{header: w.Header()}
+		h.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		response := cache.CachedResponse{
+			Status:      rec.status,
+			ContentType: rec.header.Get(utils.ContentType),
+			Body:        rec.body.Bytes(),
+		}
+		// Only successful responses are kept, errors and denied requests always run again
+		if rec.status != http.StatusOK {
+			w.WriteHeader(rec.status)
+			if _, err := w.Write(response.Body); err != nil {
+				log.Printf("error sending response %v", err)
+			}
+			return
+		}
+		if err := readCache.SetResponse(group, generation, hash, response, 0); err != nil {
+			log.Printf("error storing cached response %v", err)
+		}
+		responseReplay(w, r, response, status)
+	})
+}
+
+// Handler to invalidate the cached responses of a group after a successful change
+func handlerResponseInvalidate(h http.Handler, group string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if readCache == nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		rec := &idempotencyRecorder{header: w.Header()}
+		h.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		if rec.status < http.StatusBadRequest {
+			if err := readCache.InvalidateResponses(group); err != nil {
+				log.Printf("error invalidating cached responses %v", err)
+			}
+		}
+		w.WriteHeader(rec.status)
+		if _, err := w.Write(rec.body.Bytes()); err != nil {
+			log.Printf("error sending response %v", err)
+		}
+	})
+}
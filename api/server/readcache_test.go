@@ -0,0 +1,143 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jmpsec/osctrl/cache"
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryResponses to keep cached responses in memory for tests
+type memoryResponses struct {
+	mutex       sync.Mutex
+	generations map[string]int64
+	responses   map[string]cache.CachedResponse
+}
+
+func (m *memoryResponses) ResponseGeneration(group string) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.generations[group], nil
+}
+
+func (m *memoryResponses) GetResponse(group string, generation int64, hash string) (cache.CachedResponse, bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	response, ok := m.responses[cache.GenResponseKey(group, generation, hash)]
+	return response, ok, nil
+}
+
+func (m *memoryResponses) SetResponse(group string, generation int64, hash string, response cache.CachedResponse, expire time.Duration) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.responses[cache.GenResponseKey(group, generation, hash)] = response
+	return nil
+}
+
+func (m *memoryResponses) InvalidateResponses(group string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.generations[group]++
+	return nil
+}
+
+func useMemoryResponses(t *testing.T) {
+	readCache = &memoryResponses{generations: make(map[string]int64), responses: make(map[string]cache.CachedResponse)}
+	t.Cleanup(func() { readCache = nil })
+}
+
+func keyRequest(h http.Handler, method, target, key, scopes string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	ctx := contextValue{ctxUser: key, ctxKeyID: key, ctxKeyEnv: "env-uuid", ctxKeyScopes: scopes}
+	req = req.WithContext(context.WithValue(req.Context(), contextKey(contextAPI), ctx))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestResponseCache(t *testing.T) {
+	useMemoryResponses(t)
+	var executed int32
+	h := handlerResponseCache(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&executed, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"run":` + strconv.Itoa(int(n)) + `}`))
+	}), cache.ResponseGroupNodes)
+	first := keyRequest(h, http.MethodGet, "/api/v1/nodes/dev/all?b=2&a=1", "key1", "nodes:read", nil)
+	assert.Equal(t, http.StatusOK, first.Code)
+	assert.Equal(t, `{"run":1}`, first.Body.String())
+	assert.Equal(t, "MISS", first.Header().Get(responseCacheHeader))
+	// Same parameters in another order are served from the cache
+	hit := keyRequest(h, http.MethodGet, "/api/v1/nodes/dev/all?a=1&b=2", "key1", "nodes:read", nil)
+	assert.Equal(t, `{"run":1}`, hit.Body.String())
+	assert.Equal(t, "HIT", hit.Header().Get(responseCacheHeader))
+	assert.Equal(t, "application/json", hit.Header().Get("Content-Type"))
+	// Other parameters, keys or scopes never share responses
+	assert.Equal(t, `{"run":2}`, keyRequest(h, http.MethodGet, "/api/v1/nodes/dev/all?a=2", "key1", "nodes:read", nil).Body.String())
+	assert.Equal(t, `{"run":3}`, keyRequest(h, http.MethodGet, "/api/v1/nodes/dev/all?a=1&b=2", "key2", "nodes:read", nil).Body.String())
+	assert.Equal(t, `{"run":4}`, keyRequest(h, http.MethodGet, "/api/v1/nodes/dev/all?a=1&b=2", "key1", "nodes:read,nodes:write", nil).Body.String())
+	// Clients with the same response get 304
+	etag := hit.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+	same := keyRequest(h, http.MethodGet, "/api/v1/nodes/dev/all?a=1&b=2", "key1", "nodes:read", map[string]string{"If-None-Match": etag})
+	assert.Equal(t, http.StatusNotModified, same.Code)
+	assert.Empty(t, same.Body.String())
+	// Requests with no-cache skip the cached response and refresh it
+	bypass := keyRequest(h, http.MethodGet, "/api/v1/nodes/dev/all?a=1&b=2", "key1", "nodes:read", map[string]string{"Cache-Control": "no-cache"})
+	assert.Equal(t, `{"run":5}`, bypass.Body.String())
+	assert.Equal(t, "BYPASS", bypass.Header().Get(responseCacheHeader))
+	assert.Equal(t, `{"run":5}`, keyRequest(h, http.MethodGet, "/api/v1/nodes/dev/all?a=1&b=2", "key1", "nodes:read", nil).Body.String())
+	assert.Equal(t, int32(5), atomic.LoadInt32(&executed))
+}
+
+func TestResponseCacheInvalidate(t *testing.T) {
+	useMemoryResponses(t)
+	var executed int32
+	h := handlerResponseCache(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&executed, 1)
+		_, _ = w.Write([]byte(strconv.Itoa(int(n))))
+	}), cache.ResponseGroupNodes)
+	status := http.StatusBadRequest
+	change := handlerResponseInvalidate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}), cache.ResponseGroupNodes)
+	assert.Equal(t, "1", keyRequest(h, http.MethodGet, "/api/v1/nodes/dev/all", "key1", "", nil).Body.String())
+	// Failed changes keep the cached responses
+	assert.Equal(t, http.StatusBadRequest, keyRequest(change, http.MethodPost, "/api/v1/nodes/dev/delete", "key1", "", nil).Code)
+	assert.Equal(t, "1", keyRequest(h, http.MethodGet, "/api/v1/nodes/dev/all", "key1", "", nil).Body.String())
+	status = http.StatusOK
+	assert.Equal(t, http.StatusOK, keyRequest(change, http.MethodPost, "/api/v1/nodes/dev/delete", "key1", "", nil).Code)
+	assert.Equal(t, "2", keyRequest(h, http.MethodGet, "/api/v1/nodes/dev/all", "key1", "", nil).Body.String())
+}
+
+func TestResponseCacheErrors(t *testing.T) {
+	useMemoryResponses(t)
+	var executed int32
+	h := handlerResponseCache(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&executed, 1)
+		w.WriteHeader(http.StatusForbidden)
+	}), cache.ResponseGroupEnvironments)
+	assert.Equal(t, http.StatusForbidden, keyRequest(h, http.MethodGet, "/api/v1/environments", "key1", "", nil).Code)
+	assert.Equal(t, http.StatusForbidden, keyRequest(h, http.MethodGet, "/api/v1/environments", "key1", "", nil).Code)
+	// Errors are not cached
+	assert.Equal(t, int32(2), atomic.LoadInt32(&executed))
+}
+
+func TestETagMatch(t *testing.T) {
+	assert.True(t, etagMatch(`"abc"`, `"abc"`))
+	assert.True(t, etagMatch(`"xyz", W/"abc"`, `"abc"`))
+	assert.True(t, etagMatch(`*`, `"abc"`))
+	assert.False(t, etagMatch(`"abcd"`, `"abc"`))
+	assert.False(t, etagMatch("", `"abc"`))
+}
@@ -0,0 +1,338 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/backend"
+	"github.com/jmpsec/osctrl/cache"
+	"github.com/jmpsec/osctrl/carves"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/logging"
+	"github.com/jmpsec/osctrl/metrics"
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/jmpsec/osctrl/queries"
+	"github.com/jmpsec/osctrl/reports"
+	"github.com/jmpsec/osctrl/responses"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/tags"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/webhooks"
+)
+
+// Tags to group the routes of the API in the spec
+const (
+	tagLogin        = "login"
+	tagNodes        = "nodes"
+	tagQueries      = "queries"
+	tagCarves       = "carves"
+	tagUsers        = "users"
+	tagPlatforms    = "platforms"
+	tagEnvironments = "environments"
+	tagTags         = "tags"
+	tagReports      = "reports"
+	tagTemplates    = "templates"
+	tagResults      = "results"
+	tagSettings     = "settings"
+	tagLogs         = "logs"
+	tagStats        = "stats"
+	tagResponses    = "responses"
+	tagTrash        = "trash"
+	tagShares       = "shares"
+	tagNotices      = "notices"
+	tagExports      = "exports"
+	tagSpec         = "spec"
+	tagWebhooks     = "webhooks"
+)
+
+// apiRoute to hold one route of the API with the metadata used to generate the OpenAPI spec
+type apiRoute struct {
+	// HTTP method of the route
+	Method string
+	// Path after the API prefix, with variables as {name}
+	Path string
+	// Handler for the route
+	Handler http.HandlerFunc
+	// Short description of what the route does
+	Summary string
+	// Tag to group routes in the spec
+	Tag string
+	// Public routes are not wrapped with the authentication check
+	Public bool
+	// Scope that service keys need to use the route, routes without scope are only for users
+	Scope string
+	// Value of the type decoded from the request body, nil if there is no body
+	Request interface{}
+	// Value of the type returned with 200, nil if the response is not JSON
+	Response interface{}
+	// Content type of the response when it is not JSON
+	ContentType string
+	// Cached responses are kept for a short time, changes to routes with the same tag invalidate them
+	Cached bool
+}
+
+// apiRoutes to get all the routes of the API, in the order they are matched.
+// Every endpoint must be added here, so it is registered and documented at the same time.
+func apiRoutes() []apiRoute {
+	return []apiRoute{
+		// API: spec
+		{Method: http.MethodGet, Path: apiOpenAPIPath, Handler: apiOpenAPIHandler, Summary: "OpenAPI spec of this API", Tag: tagSpec, Public: true, Response: map[string]interface{}{}},
+		// API: login
+		{Method: http.MethodPost, Path: apiLoginPath + "/{env}", Handler: apiLoginHandler, Summary: "Login to get an API token", Tag: tagLogin, Request: types.ApiLoginRequest{}, Response: types.ApiLoginResponse{}},
+		// API: nodes across environments
+		{Method: http.MethodGet, Path: apiNodesPath + "/search", Handler: apiSearchNodesHandler, Summary: "Search nodes by the start of their UUID, hostname, IP address or serial with ?q=, across the environments with access", Tag: tagNodes, Response: []nodes.NodeMatch{}},
+		{Method: http.MethodGet, Path: apiNodesPath + "/compare", Handler: apiCompareNodesHandler, Summary: "Compare the configuration served, enrollment metadata, tags, attributes, versions and health of two nodes with ?a=env/uuid&b=env/uuid, b can be env/baseline for the majority and median values of the environment. Differences are paginated per section with ?page=&per_page= and limited with ?section=", Tag: tagNodes, Response: nodes.NodeComparison{}},
+		// API: nodes by environment
+		{Method: http.MethodGet, Path: apiNodesPath + "/{env}/node/{node}", Handler: apiNodeHandler, Summary: "Get one node", Tag: tagNodes, Scope: users.ScopeNodesRead, Response: nodes.OsqueryNode{}},
+		{Method: http.MethodGet, Path: apiNodesPath + "/{env}/{node}/attributes", Handler: apiNodeAttributesHandler, Summary: "Get the custom attributes of a node", Tag: tagNodes, Scope: users.ScopeNodesRead, Response: []nodes.NodeAttribute{}},
+		{Method: http.MethodPut, Path: apiNodesPath + "/{env}/{node}/attributes", Handler: apiSetNodeAttributesHandler, Summary: "Set custom attributes of a node as namespace.name keys, null values delete them. Service keys can write the namespaces of their attributes scopes", Tag: tagNodes, Scope: users.ScopeAttributesWrite, Request: types.ApiNodeAttributesRequest{}, Response: []nodes.NodeAttribute{}},
+		{Method: http.MethodGet, Path: apiNodesPath + "/{env}/attributes", Handler: apiEnvAttributesHandler, Summary: "Export the custom attributes of the nodes, by node UUID", Tag: tagNodes, Scope: users.ScopeNodesRead, Response: map[string][]nodes.NodeAttribute{}},
+		{Method: http.MethodPut, Path: apiNodesPath + "/{env}/attributes", Handler: apiBulkAttributesHandler, Summary: "Set custom attributes of many nodes, by node UUID", Tag: tagNodes, Scope: users.ScopeAttributesWrite, Request: types.ApiBulkAttributesRequest{}, Response: types.ApiBulkAttributesResponse{}},
+		{Method: http.MethodDelete, Path: apiNodesPath + "/attributes/{namespace}", Handler: apiDeleteAttributeNamespaceHandler, Summary: "Delete the custom attributes of a namespace from all nodes", Tag: tagNodes, Response: types.ApiGenericResponse{}},
+		{Method: http.MethodGet, Path: apiNodesPath + "/{env}/{node}/network-history", Handler: apiNodeNetworkHistoryHandler, Summary: "Get the IP addresses used by a node", Tag: tagNodes, Scope: users.ScopeNodesRead, Response: []nodes.NodeHistoryIPAddress{}},
+		{Method: http.MethodGet, Path: apiNodesPath + "/{env}/{node}/membership", Handler: apiNodeMembershipHandler, Summary: "Get the environments and tags of a node at one point in time with ?at=, now by default", Tag: tagNodes, Scope: users.ScopeNodesRead, Response: nodes.MembershipState{}},
+		{Method: http.MethodGet, Path: apiNodesPath + "/{env}/{node}/membership/changes", Handler: apiNodeMembershipChangesHandler, Summary: "Get the changes of environments and tags of a node within ?from= and ?to=, as JSON or ?format=csv to export them", Tag: tagNodes, Scope: users.ScopeNodesRead, Response: []nodes.NodeMembership{}},
+		{Method: http.MethodGet, Path: apiNodesPath + "/{env}/{node}/config-history", Handler: apiNodeConfigHistoryHandler, Summary: "Get the lineage of the configuration served to a node, with ?from= and ?to= point ids to diff the configurations rendered again", Tag: tagNodes, Scope: users.ScopeNodesRead, Response: environments.ConfigHistory{}},
+		{Method: http.MethodPost, Path: apiNodesPath + "/{env}/{node}/schedule-override", Handler: apiScheduleOverrideHandler, Summary: "Override the interval of a scheduled query for a node", Tag: tagNodes, Scope: users.ScopeNodesWrite, Request: types.ApiScheduleOverrideRequest{}, Response: environments.ScheduleOverride{}},
+		{Method: http.MethodPost, Path: apiNodesPath + "/{env}/{node}/status-token", Handler: apiStatusTokenHandler, Summary: "Generate a short-lived link to the status of a node for help desk", Tag: tagNodes, Scope: users.ScopeNodesWrite, Request: types.ApiStatusTokenRequest{}, Response: types.ApiStatusTokenResponse{}},
+		{Method: http.MethodPost, Path: apiNodesPath + "/{env}/{node}/pause", Handler: apiNodePauseHandler, Summary: "Pause a node, it keeps sending logs but does not get queries nor carves, with an optional reason and expiration", Tag: tagNodes, Scope: users.ScopeNodesWrite, Request: types.ApiNodePauseRequest{}, Response: nodes.NodePause{}},
+		{Method: http.MethodPost, Path: apiNodesPath + "/{env}/{node}/unpause", Handler: apiNodeUnpauseHandler, Summary: "Unpause a node, the queries still active are served again", Tag: tagNodes, Scope: users.ScopeNodesWrite, Response: nodes.NodePause{}},
+		{Method: http.MethodGet, Path: apiNodesPath + "/{env}/pauses", Handler: apiNodePausesHandler, Summary: "Get the paused nodes", Tag: tagNodes, Scope: users.ScopeNodesRead, Response: []nodes.NodePause{}},
+		{Method: http.MethodGet, Path: apiNodesPath + "/{env}/schedule-overrides", Handler: apiScheduleOverridesHandler, Summary: "Get the active schedule overrides", Tag: tagNodes, Scope: users.ScopeNodesRead, Response: []environments.ScheduleOverride{}},
+		{Method: http.MethodPost, Path: apiNodesPath + "/{env}/decommission", Handler: apiDecommissionNodeHandler, Summary: "Decommission a node, osquery is removed and the node archived once it stops contacting", Tag: tagNodes, Scope: users.ScopeNodesWrite, Request: types.ApiNodeGenericRequest{}, Response: nodes.NodeDecommission{}},
+		{Method: http.MethodGet, Path: apiNodesPath + "/{env}/decommissions", Handler: apiDecommissionsHandler, Summary: "Get decommissioned nodes, by state with ?state=", Tag: tagNodes, Scope: users.ScopeNodesRead, Response: []nodes.NodeDecommission{}},
+		{Method: http.MethodGet, Path: apiNodesPath + "/{env}/merges", Handler: apiNodeMergesHandler, Summary: "Get merges of duplicate nodes, by state with ?state=", Tag: tagNodes, Scope: users.ScopeNodesRead, Response: []nodes.NodeMerge{}},
+		{Method: http.MethodPost, Path: apiNodesPath + "/{env}/merges/{id}", Handler: apiNodeMergeReviewHandler, Summary: "Approve, reject or split a merge of duplicate nodes", Tag: tagNodes, Scope: users.ScopeNodesWrite, Request: types.ApiNodeMergeReviewRequest{}, Response: nodes.NodeMerge{}},
+		{Method: http.MethodPost, Path: apiNodesPath + "/{env}/merge", Handler: apiNodeMergeHandler, Summary: "Merge a duplicate node into the canonical node, the duplicate is archived", Tag: tagNodes, Scope: users.ScopeNodesWrite, Request: types.ApiNodeMergeRequest{}, Response: nodes.NodeMerge{}},
+		{Method: http.MethodPost, Path: apiNodesPath + "/{env}/delete", Handler: apiDeleteNodeHandler, Summary: "Delete a node", Tag: tagNodes, Scope: users.ScopeNodesWrite, Request: types.ApiNodeGenericRequest{}, Response: types.ApiGenericResponse{}},
+		{Method: http.MethodGet, Path: apiNodesPath + "/{env}/all", Handler: apiAllNodesHandler, Summary: "Get all nodes, filtered by enroll metadata with ?meta=name:value and by attributes with ?attr=name>value, sorted with ?sort=attr:name", Tag: tagNodes, Scope: users.ScopeNodesRead, Response: []nodes.OsqueryNode{}, Cached: true},
+		{Method: http.MethodGet, Path: apiNodesPath + "/{env}/active", Handler: apiActiveNodesHandler, Summary: "Get active nodes, filtered by enroll metadata with ?meta=name:value and by attributes with ?attr=name>value, sorted with ?sort=attr:name", Tag: tagNodes, Scope: users.ScopeNodesRead, Response: []nodes.OsqueryNode{}, Cached: true},
+		{Method: http.MethodGet, Path: apiNodesPath + "/{env}/inactive", Handler: apiInactiveNodesHandler, Summary: "Get inactive nodes, filtered by enroll metadata with ?meta=name:value and by attributes with ?attr=name>value, sorted with ?sort=attr:name", Tag: tagNodes, Scope: users.ScopeNodesRead, Response: []nodes.OsqueryNode{}, Cached: true},
+		// API: queries by environment
+		{Method: http.MethodGet, Path: apiQueriesPath + "/{env}", Handler: apiAllQueriesShowHandler, Summary: "Get completed queries, filtered by ?label=key=value and ?ticket=", Tag: tagQueries, Scope: users.ScopeQueriesRead, Response: []queries.DistributedQuery{}},
+		{Method: http.MethodPost, Path: apiQueriesPath + "/{env}", Handler: apiQueriesRunHandler, Summary: "Run a new query, from SQL or from a template with its variables. Queries using tables restricted by the policy of the user are rejected unless an admin overrides it with a justification", Tag: tagQueries, Scope: users.ScopeQueriesWrite, Request: types.ApiDistributedQueryRequest{}, Response: types.ApiQueriesResponse{}},
+		{Method: http.MethodGet, Path: apiQueriesPath + "/{env}/groups", Handler: apiQueryGroupsHandler, Summary: "Get the concurrency groups of queries, with their limits, active queries and how many are in flight", Tag: tagQueries, Scope: users.ScopeQueriesRead, Response: []queries.GroupUtilization{}},
+		{Method: http.MethodPost, Path: apiQueriesPath + "/{env}/groups", Handler: apiQueryGroupLimitsHandler, Summary: "Set the concurrency limits of a group of queries, per node and across the fleet, only for admins", Tag: tagQueries, Scope: users.ScopeQueriesWrite, Request: types.ApiQueryGroupRequest{}, Response: types.ApiGenericResponse{}},
+		{Method: http.MethodGet, Path: apiQueriesPath + "/{env}/policies", Handler: apiQueryPoliciesHandler, Summary: "Get the query policies of the environment, with the tables and columns each user or service key can query", Tag: tagQueries, Scope: users.ScopeQueriesRead, Response: []queries.QueryPolicy{}},
+		{Method: http.MethodPost, Path: apiQueriesPath + "/{env}/policies", Handler: apiSetQueryPolicyHandler, Summary: "Set the query policy of a user, a service key as apikey:name or * for everyone else, only for admins and audited", Tag: tagQueries, Scope: users.ScopeQueriesWrite, Request: types.ApiQueryPolicyRequest{}, Response: types.ApiGenericResponse{}},
+		{Method: http.MethodPost, Path: apiQueriesPath + "/{env}/policies/delete", Handler: apiDeleteQueryPolicyHandler, Summary: "Delete the query policy of a subject, only for admins and audited", Tag: tagQueries, Scope: users.ScopeQueriesWrite, Request: types.ApiQueryPolicyRequest{}, Response: types.ApiGenericResponse{}},
+		{Method: http.MethodPost, Path: apiQueriesPath + "/{env}/{name}/visibility", Handler: apiQueryVisibilityHandler, Summary: "Change who can see the results of a query: public, creator or team, only for its creator and admins and audited", Tag: tagQueries, Scope: users.ScopeQueriesWrite, Request: types.ApiQueryVisibilityRequest{}, Response: types.ApiGenericResponse{}},
+		{Method: http.MethodPost, Path: apiQueriesPath + "/{env}/{name}/break-glass", Handler: apiQueryBreakGlassHandler, Summary: "See the results of a restricted query for an hour, only for admins with a reason and audited", Tag: tagQueries, Scope: users.ScopeQueriesRead, Request: types.ApiBreakGlassRequest{}, Response: queries.QueryAccessGrant{}},
+		{Method: http.MethodGet, Path: apiQueriesPath + "/{env}/{name}", Handler: apiQueryShowHandler, Summary: "Get one query with its labels, with the schema inferred from its results if ?schema=true", Tag: tagQueries, Scope: users.ScopeQueriesRead, Response: queries.DistributedQuery{}},
+		{Method: http.MethodGet, Path: apiQueriesPath + "/{env}/{name}/results", Handler: apiQueryExportHandler, Summary: "Export the results of a query with typed columns, in ?format=csv, json or parquet. Large results, or any with ?async=true, are materialized by an export job returned with 202 to download it in ranges", Tag: tagQueries, Scope: users.ScopeQueriesRead, ContentType: "application/vnd.apache.parquet"},
+		{Method: http.MethodGet, Path: apiQueriesPath + "/{env}/{name}/archive", Handler: apiQueryArchiveHandler, Summary: "Get the archive of a query, with the size and the SHA-256 of the archived results and the progress of its rehydration", Tag: tagQueries, Scope: users.ScopeQueriesRead, Response: queries.QueryArchive{}},
+		{Method: http.MethodGet, Path: apiQueriesPath + "/{env}/{name}/archive/download", Handler: apiQueryArchiveDownloadHandler, Summary: "Download the archived results of a query as lines of JSON compressed with gzip, read directly from the archive with the SHA-256 in the X-Archive-SHA256 header", Tag: tagQueries, Scope: users.ScopeQueriesRead, ContentType: "application/gzip"},
+		{Method: http.MethodPost, Path: apiQueriesPath + "/{env}/{name}/rehydrate", Handler: apiQueryRehydrateHandler, Summary: "Restore the results of an archived query in the background, they are verified with their hashes and evicted again after the TTL. Audited", Tag: tagQueries, Scope: users.ScopeQueriesRead, Response: queries.QueryArchive{}},
+		{Method: http.MethodPost, Path: apiQueriesPath + "/{env}/{name}/context", Handler: apiQueryContextHandler, Summary: "Change the investigation context of a query, only for its creator and admins", Tag: tagQueries, Scope: users.ScopeQueriesWrite, Request: queries.QueryContext{}, Response: types.ApiGenericResponse{}},
+		{Method: http.MethodPost, Path: apiQueriesPath + "/{env}/{name}/delete", Handler: apiQueryDeleteHandler, Summary: "Delete a query or carve. It is moved to the trash and can be restored within the retention window, ?hard=true deletes it permanently, only for admins and audited", Tag: tagQueries, Scope: users.ScopeQueriesWrite, Response: types.ApiGenericResponse{}},
+		{Method: http.MethodGet, Path: apiQueriesPath + "/{env}/profile/{name}", Handler: apiQueryProfileHandler, Summary: "Get the cost report of a profile run", Tag: tagQueries, Scope: users.ScopeQueriesRead, Response: queries.ProfileReport{}},
+		{Method: http.MethodPost, Path: apiQueriesPath + "/{env}/profile/{name}/launch", Handler: apiQueryProfileLaunchHandler, Summary: "Launch a profiled query to all its targets", Tag: tagQueries, Scope: users.ScopeQueriesWrite, Response: types.ApiQueriesResponse{}},
+		{Method: http.MethodGet, Path: apiQueriesPath + "/{env}/results/{name}", Handler: apiQueryResultsHandler, Summary: "Get the results of a query by node, with its investigation context if ?context=true", Tag: tagQueries, Scope: users.ScopeQueriesRead, Response: APIQueryData{}},
+		{Method: http.MethodGet, Path: apiAllQueriesPath + "/{env}", Handler: apiAllQueriesShowHandler, Summary: "Get completed queries, filtered by ?label=key=value and ?ticket=", Tag: tagQueries, Scope: users.ScopeQueriesRead, Response: []queries.DistributedQuery{}},
+		// API: sharing exports of queries
+		{Method: http.MethodGet, Path: apiSharesPath + "/{env}", Handler: apiSharesHandler, Summary: "Get the sharing exports of an environment", Tag: tagShares, Scope: users.ScopeQueriesRead, Response: []queries.ShareExport{}},
+		{Method: http.MethodPost, Path: apiSharesPath + "/{env}", Handler: apiShareCreateHandler, Summary: "Export queries with their redacted results and the nodes that sent them as a ZIP to share with external responders, generated asynchronously", Tag: tagShares, Scope: users.ScopeQueriesRead, Request: types.ApiShareRequest{}, Response: queries.ShareExport{}},
+		{Method: http.MethodGet, Path: apiSharesPath + "/{env}/{name}", Handler: apiShareHandler, Summary: "Get the status of a sharing export", Tag: tagShares, Scope: users.ScopeQueriesRead, Response: queries.ShareExport{}},
+		{Method: http.MethodGet, Path: apiSharesPath + "/{env}/{name}/download", Handler: apiShareDownloadHandler, Summary: "Download a sharing export once ready", Tag: tagShares, Scope: users.ScopeQueriesRead, ContentType: queries.ShareContentType},
+		// API: notices shown as banners to users
+		{Method: http.MethodGet, Path: apiNoticesPath, Handler: apiNoticesHandler, Summary: "Get the active notices, only the ones shown in an environment with ?env= and with the expired and removed ones with ?history=true, only for admins", Tag: tagNotices, Response: []users.Notice{}},
+		{Method: http.MethodPost, Path: apiNoticesPath, Handler: apiNewNoticeHandler, Summary: "Create a notice, global or for some environments, only for admins", Tag: tagNotices, Request: types.ApiNoticeRequest{}, Response: users.Notice{}},
+		{Method: http.MethodPost, Path: apiNoticesPath + "/{id}", Handler: apiUpdateNoticeHandler, Summary: "Update a notice, only for admins", Tag: tagNotices, Request: types.ApiNoticeRequest{}, Response: users.Notice{}},
+		{Method: http.MethodPost, Path: apiNoticesPath + "/{id}/delete", Handler: apiDeleteNoticeHandler, Summary: "Remove a notice, it is kept in the history, only for admins", Tag: tagNotices, Response: types.ApiGenericResponse{}},
+		{Method: http.MethodPost, Path: apiNoticesPath + "/{id}/ack", Handler: apiAckNoticeHandler, Summary: "Acknowledge a notice as the user of the request", Tag: tagNotices, Response: types.ApiGenericResponse{}},
+		{Method: http.MethodGet, Path: apiNoticesPath + "/{id}/acks", Handler: apiNoticeAcksHandler, Summary: "Get the users that acknowledged a notice, only for admins", Tag: tagNotices, Response: []users.NoticeAck{}},
+		// API: export jobs of large results
+		{Method: http.MethodGet, Path: apiExportsPath + "/{env}", Handler: apiExportsHandler, Summary: "Get the export jobs of an environment, users only get their own", Tag: tagExports, Scope: users.ScopeQueriesRead, Response: []queries.ExportJob{}},
+		{Method: http.MethodGet, Path: apiExportsPath + "/{env}/{job}", Handler: apiExportHandler, Summary: "Get the status of an export job, with the size and the SHA-256 of the artifact once ready", Tag: tagExports, Scope: users.ScopeQueriesRead, Response: queries.ExportJob{}},
+		{Method: http.MethodGet, Path: apiExportsPath + "/{env}/{job}/download", Handler: apiExportDownloadHandler, Summary: "Download the artifact of an export job once ready, with Range requests to resume downloads and the SHA-256 in the X-Export-SHA256 header", Tag: tagExports, Scope: users.ScopeQueriesRead, ContentType: "application/octet-stream"},
+		{Method: http.MethodPost, Path: apiExportsPath + "/{env}/{job}/cancel", Handler: apiExportCancelHandler, Summary: "Cancel an export job, only for its creator and admins", Tag: tagExports, Scope: users.ScopeQueriesRead, Response: types.ApiGenericResponse{}},
+		// API: query templates
+		{Method: http.MethodGet, Path: apiTemplatesPath, Handler: apiTemplatesHandler, Summary: "Get all query templates", Tag: tagTemplates, Response: []queries.QueryTemplate{}},
+		{Method: http.MethodPost, Path: apiTemplatesPath, Handler: apiTemplateCreateHandler, Summary: "Create a query template, only for admins", Tag: tagTemplates, Request: types.ApiQueryTemplateRequest{}, Response: queries.QueryTemplate{}},
+		{Method: http.MethodGet, Path: apiTemplatesPath + "/{name}/versions", Handler: apiTemplateVersionsHandler, Summary: "Get all the versions of a query template with the changelog", Tag: tagTemplates, Response: []queries.QueryTemplateVersion{}},
+		{Method: http.MethodPost, Path: apiTemplatesPath + "/{name}/delete", Handler: apiTemplateDeleteHandler, Summary: "Delete a query template, its versions are kept, only for admins. It is moved to the trash and can be restored within the retention window, ?hard=true deletes it permanently and is audited", Tag: tagTemplates, Response: types.ApiGenericResponse{}},
+		{Method: http.MethodGet, Path: apiTemplatesPath + "/{name}", Handler: apiTemplateHandler, Summary: "Get one query template", Tag: tagTemplates, Response: queries.QueryTemplate{}},
+		{Method: http.MethodPost, Path: apiTemplatesPath + "/{name}", Handler: apiTemplateUpdateHandler, Summary: "Update a query template as a new version with a changelog, only for admins", Tag: tagTemplates, Request: types.ApiQueryTemplateRequest{}, Response: queries.QueryTemplate{}},
+		// API: carves by environment
+		{Method: http.MethodGet, Path: apiCarvesPath + "/{env}", Handler: apiCarvesShowHandler, Summary: "Get all carves", Tag: tagCarves, Scope: users.ScopeCarvesRead, Response: []carves.CarvedFile{}},
+		{Method: http.MethodPost, Path: apiCarvesPath + "/{env}", Handler: apiCarvesRunHandler, Summary: "Run a new carve", Tag: tagCarves, Scope: users.ScopeCarvesWrite, Request: types.ApiDistributedCarveRequest{}, Response: types.ApiQueriesResponse{}},
+		{Method: http.MethodGet, Path: apiCarvesPath + "/{env}/recurring", Handler: apiRecurringCarvesHandler, Summary: "Get the recurring carves for legal holds, with the storage used by each case", Tag: tagCarves, Scope: users.ScopeCarvesRead, Response: []queries.RecurringCarveDetail{}},
+		{Method: http.MethodPost, Path: apiCarvesPath + "/{env}/recurring", Handler: apiRecurringCarveCreateHandler, Summary: "Create a carve launched in the same nodes on an interval or cron recurrence, until the end date or stopped", Tag: tagCarves, Scope: users.ScopeCarvesWrite, Request: types.ApiRecurringCarveRequest{}, Response: types.ApiQueriesResponse{}},
+		{Method: http.MethodGet, Path: apiCarvesPath + "/{env}/recurring/{name}", Handler: apiRecurringCarveHandler, Summary: "Get a recurring carve with the status of each occurrence per node", Tag: tagCarves, Scope: users.ScopeCarvesRead, Response: queries.RecurringCarveDetail{}},
+		{Method: http.MethodPost, Path: apiCarvesPath + "/{env}/recurring/{name}/{action:pause|resume|stop}", Handler: apiRecurringCarveActionHandler, Summary: "Pause, resume or stop a recurring carve, the carves already collected are kept", Tag: tagCarves, Scope: users.ScopeCarvesWrite, Response: types.ApiGenericResponse{}},
+		{Method: http.MethodGet, Path: apiCarvesPath + "/{env}/limits", Handler: apiCarveLimitsHandler, Summary: "Get the carve limits of an environment with the current use against each one and the rejections of the daily window", Tag: tagCarves, Scope: users.ScopeCarvesRead, Response: carves.CarveUsage{}},
+		{Method: http.MethodPost, Path: apiCarvesPath + "/{env}/limits", Handler: apiSetCarveLimitsHandler, Summary: "Set the carve limits of an environment: max carve size, concurrent sessions per node and environment, daily byte budget and denied paths, zero values are unlimited", Tag: tagCarves, Scope: users.ScopeCarvesWrite, Request: types.ApiCarveLimitsRequest{}, Response: carves.CarveLimits{}},
+		{Method: http.MethodGet, Path: apiCarvesPath + "/{env}/rejections", Handler: apiCarveRejectionsHandler, Summary: "Get the carves rejected by the limits of an environment since ?since=, the daily window by default", Tag: tagCarves, Scope: users.ScopeCarvesRead, Response: []carves.CarveRejection{}},
+		{Method: http.MethodGet, Path: apiCarvesPath + "/{env}/{name}", Handler: apiCarveShowHandler, Summary: "Get the files of one carve", Tag: tagCarves, Scope: users.ScopeCarvesRead, Response: []carves.CarvedFile{}},
+		{Method: http.MethodGet, Path: apiCarvesPath + "/{env}/download/{sessionid}", Handler: apiCarveDownloadHandler, Summary: "Download one carved file, if the scan policy allows it", Tag: tagCarves, Scope: users.ScopeCarvesRead, ContentType: "application/octet-stream"},
+		{Method: http.MethodGet, Path: apiCarvesPath + "/{env}/{sessionid}/files", Handler: apiCarveFilesHandler, Summary: "Get the manifest of the files in one carved tar archive, or extract and download one file with ?path=, extractions are recorded", Tag: tagCarves, Scope: users.ScopeCarvesRead, Response: carves.CarveManifest{}},
+		{Method: http.MethodPost, Path: apiCarvesPath + "/{env}/{sessionid}/index", Handler: apiCarveIndexHandler, Summary: "Build again the manifest of the files in one carved file", Tag: tagCarves, Scope: users.ScopeCarvesWrite, Response: types.ApiGenericResponse{}},
+		{Method: http.MethodPost, Path: apiCarvesPath + "/{env}/scan/{sessionid}", Handler: apiCarveScanHandler, Summary: "Scan again one carved file", Tag: tagCarves, Scope: users.ScopeCarvesWrite, Response: types.ApiGenericResponse{}},
+		// API: results by environment
+		{Method: http.MethodGet, Path: apiResultsPath + "/{env}", Handler: apiResultsHandler, Summary: "Get results of scheduled queries as events, filtered with ?node=&name=&action=&since=6h&after=&limit=", Tag: tagResults, Scope: users.ScopeResultsRead, Response: []types.ResultEvent{}},
+		{Method: http.MethodGet, Path: apiResultsPath + "/{env}/stream", Handler: apiResultsStreamHandler, Summary: "Stream new results of scheduled queries as events, one JSON per line, with the same filters", Tag: tagResults, Scope: users.ScopeResultsRead, ContentType: resultsStreamType},
+		{Method: http.MethodGet, Path: apiResultsPath + "/{env}/export", Handler: apiResultsExportHandler, Summary: "Export results of scheduled queries as events, in ?format=csv or ndjson, with the same filters", Tag: tagResults, Scope: users.ScopeResultsRead, ContentType: "text/csv"},
+		// API: response actions by environment
+		{Method: http.MethodGet, Path: apiResponsesPath + "/{env}", Handler: apiResponseActionsHandler, Summary: "Get the latest response actions with the events that fired them, up to ?limit=", Tag: tagResponses, Scope: users.ScopeActionsRead, Response: []responses.ResponseAction{}},
+		{Method: http.MethodGet, Path: apiResponsesPath + "/{env}/config", Handler: apiResponseConfigHandler, Summary: "Get the response actions configuration and triggers of an environment", Tag: tagResponses, Scope: users.ScopeActionsRead, Response: responses.EnvResponses{}},
+		{Method: http.MethodPost, Path: apiResponsesPath + "/{env}/config", Handler: apiSetResponseConfigHandler, Summary: "Save the response actions configuration of an environment, or reset its circuit breaker", Tag: tagResponses, Scope: users.ScopeActionsWrite, Request: types.ApiResponseConfigRequest{}, Response: responses.EnvResponses{}},
+		{Method: http.MethodPost, Path: apiResponsesPath + "/{env}/triggers", Handler: apiSetResponseTriggerHandler, Summary: "Create or update a response trigger", Tag: tagResponses, Scope: users.ScopeActionsWrite, Request: types.ApiResponseTriggerRequest{}, Response: responses.ResponseTrigger{}},
+		{Method: http.MethodPost, Path: apiResponsesPath + "/{env}/triggers/delete", Handler: apiDeleteResponseTriggerHandler, Summary: "Delete a response trigger by name, recorded actions are kept", Tag: tagResponses, Scope: users.ScopeActionsWrite, Request: types.ApiResponseTriggerRequest{}, Response: types.ApiGenericResponse{}},
+		{Method: http.MethodGet, Path: apiResponsesPath + "/{env}/mutes", Handler: apiMutesHandler, Summary: "Get the active mutes of an environment and the ones expired in the last days, with the suppressed events", Tag: tagResponses, Scope: users.ScopeActionsRead, Response: []responses.Mute{}},
+		{Method: http.MethodPost, Path: apiResponsesPath + "/{env}/mutes", Handler: apiSetMuteHandler, Summary: "Mute notifications for a node or for the environment until they expire, permanent mutes need admin access", Tag: tagResponses, Scope: users.ScopeActionsWrite, Request: types.ApiMuteRequest{}, Response: responses.Mute{}},
+		{Method: http.MethodPost, Path: apiResponsesPath + "/{env}/mutes/delete", Handler: apiDeleteMuteHandler, Summary: "Lift a mute by id before it expires", Tag: tagResponses, Scope: users.ScopeActionsWrite, Request: types.ApiMuteRequest{}, Response: types.ApiGenericResponse{}},
+		{Method: http.MethodPost, Path: apiResponsesPath + "/{env}/alert", Handler: apiResponseAlertHandler, Summary: "Fire a response trigger for one node with the fields of an external alert", Tag: tagResponses, Scope: users.ScopeActionsWrite, Request: types.ApiResponseAlertRequest{}, Response: responses.ResponseAction{}},
+		// API: webhooks
+		{Method: http.MethodGet, Path: apiWebhooksPath + "/{env}", Handler: apiWebhooksHandler, Summary: "Get the webhook endpoints of an environment, without their secrets", Tag: tagWebhooks, Scope: users.ScopeEnvironmentsRead, Response: []webhooks.WebhookEndpoint{}},
+		{Method: http.MethodPost, Path: apiWebhooksPath + "/{env}", Handler: apiCreateWebhookHandler, Summary: "Create a webhook endpoint subscribed to the events, all if empty, the secret to verify signatures is only returned here", Tag: tagWebhooks, Scope: users.ScopeEnvironmentsWrite, Request: types.ApiWebhookRequest{}, Response: types.ApiWebhookSecretResponse{}},
+		{Method: http.MethodPost, Path: apiWebhooksPath + "/{env}/{name}/rotate", Handler: apiRotateWebhookHandler, Summary: "Rotate the secret of a webhook endpoint, the new secret is only returned here", Tag: tagWebhooks, Scope: users.ScopeEnvironmentsWrite, Response: types.ApiWebhookSecretResponse{}},
+		{Method: http.MethodPost, Path: apiWebhooksPath + "/{env}/{name}/{action:enable|disable|delete}", Handler: apiWebhookActionHandler, Summary: "Enable, disable or delete a webhook endpoint, disabled endpoints get no new deliveries", Tag: tagWebhooks, Scope: users.ScopeEnvironmentsWrite, Response: types.ApiGenericResponse{}},
+		{Method: http.MethodPost, Path: apiWebhooksPath + "/{env}/{name}/test", Handler: apiTestWebhookHandler, Summary: "Send a test event to a webhook endpoint right away and return the delivery with the response code", Tag: tagWebhooks, Scope: users.ScopeEnvironmentsWrite, Response: webhooks.WebhookDelivery{}},
+		{Method: http.MethodGet, Path: apiWebhooksPath + "/{env}/{name}/deliveries", Handler: apiWebhookDeliveriesHandler, Summary: "Get the latest deliveries of a webhook endpoint, the dead letters with ?state=failed, up to ?limit=", Tag: tagWebhooks, Scope: users.ScopeEnvironmentsRead, Response: []webhooks.WebhookDelivery{}},
+		{Method: http.MethodPost, Path: apiWebhooksPath + "/{env}/{name}/deliveries/{id}/redeliver", Handler: apiRedeliverWebhookHandler, Summary: "Queue again a delivery of a webhook endpoint, with all its attempts available", Tag: tagWebhooks, Scope: users.ScopeEnvironmentsWrite, Response: webhooks.WebhookDelivery{}},
+		{Method: http.MethodGet, Path: apiWebhooksPath + "/{env}/history/{entity:query|carve|response|endpoint}/{id}", Handler: apiWebhookHistoryHandler, Summary: "Get all the webhook attempts for an entity, like the query or carve with the name or carve ID", Tag: tagWebhooks, Scope: users.ScopeEnvironmentsRead, Response: []webhooks.WebhookAttempt{}},
+		// API: dead letters of failed log deliveries
+		{Method: http.MethodGet, Path: apiLogsPath + "/dlq", Handler: apiDeadLettersHandler, Summary: "Get the counts by reason and the latest dead letters of failed log deliveries, filtered with ?backend=&reason=&env=&limit=", Tag: tagLogs, Response: logging.DeadLetterReport{}},
+		{Method: http.MethodPost, Path: apiLogsPath + "/dlq/replay", Handler: apiDeadLettersReplayHandler, Summary: "Replay dead letters through osctrl-tls, optionally to a different backend", Tag: tagLogs, Request: types.ApiDeadLetterRequest{}, Response: types.ApiGenericResponse{}},
+		{Method: http.MethodPost, Path: apiLogsPath + "/dlq/purge", Handler: apiDeadLettersPurgeHandler, Summary: "Purge dead letters", Tag: tagLogs, Request: types.ApiDeadLetterRequest{}, Response: types.ApiGenericResponse{}},
+		// API: statistics
+		{Method: http.MethodGet, Path: apiStatsPath + "/requests", Handler: apiRequestStatsHandler, Summary: "Get the requests, errors and latencies by route and environment of each osctrl-tls instance for 5m, 1h and 24h, or rows of one window with ?window=", Tag: tagStats, Response: []metrics.RequestSnapshot{}},
+		{Method: http.MethodGet, Path: apiStatsPath + "/cache", Handler: apiCacheStatsHandler, Summary: "Get the keys in redis by family with memory estimated from ?sample= keys of each family, only for admins", Tag: tagStats, Response: cache.CacheStats{}},
+		{Method: http.MethodGet, Path: apiStatsPath + "/db", Handler: apiPoolStatsHandler, Summary: "Get the open, in use and idle connections, waits and closes of the DB pools of each instance, only for admins", Tag: tagStats, Response: []backend.InstancePoolStats{}},
+		{Method: http.MethodGet, Path: apiStatsPath + "/{env}/skew", Handler: apiSkewStatsHandler, Summary: "Get the distribution of clock skew of the nodes of an environment, nodes further than the threshold are skewed", Tag: tagStats, Response: nodes.SkewDistribution{}},
+		// API: users
+		{Method: http.MethodGet, Path: apiUsersPath + "/{username}/permissions", Handler: apiUserPermissionsHandler, Summary: "Get the permissions of a user by environment", Tag: tagUsers, Response: users.UserAccess{}},
+		{Method: http.MethodPut, Path: apiUsersPath + "/{username}/permissions", Handler: apiUserPermissionsPutHandler, Summary: "Replace all the permissions of a user", Tag: tagUsers, Request: users.UserAccess{}, Response: users.AccessChange{}},
+		{Method: http.MethodGet, Path: apiUsersPath + "/teams", Handler: apiTeamsHandler, Summary: "Get the teams with their members, teams can see the results of queries restricted to them", Tag: tagUsers, Response: map[string][]string{}},
+		{Method: http.MethodPost, Path: apiUsersPath + "/teams", Handler: apiTeamMemberHandler, Summary: "Add a user to a team or remove it, with action add or remove", Tag: tagUsers, Request: types.ApiTeamMemberRequest{}, Response: types.ApiGenericResponse{}},
+		{Method: http.MethodGet, Path: apiUsersPath + "/lockouts", Handler: apiLockoutsHandler, Summary: "Get the active lockouts after failed logins", Tag: tagUsers, Response: []users.LoginLockout{}},
+		{Method: http.MethodPost, Path: apiUsersPath + "/unlock", Handler: apiUnlockHandler, Summary: "Unlock a username or an IP address locked out after failed logins", Tag: tagUsers, Request: types.ApiUnlockRequest{}, Response: types.ApiGenericResponse{}},
+		{Method: http.MethodGet, Path: apiUsersPath + "/{username}", Handler: apiUserHandler, Summary: "Get one user", Tag: tagUsers, Response: users.AdminUser{}},
+		{Method: http.MethodGet, Path: apiUsersPath, Handler: apiUsersHandler, Summary: "Get all users", Tag: tagUsers, Response: []users.AdminUser{}},
+		// API: platforms
+		{Method: http.MethodGet, Path: apiPlatformsPath, Handler: apiPlatformsHandler, Summary: "Get all platforms", Tag: tagPlatforms, Response: []string{}},
+		// API: environments
+		{Method: http.MethodGet, Path: apiEnvironmentsPath + "/categories", Handler: apiCategoryPoliciesHandler, Summary: "Get the policies of the categories of environments: confirmation of launches over a number of targets, notes on changes of configuration and default query expiration", Tag: tagEnvironments, Response: []environments.CategoryPolicy{}},
+		{Method: http.MethodPost, Path: apiEnvironmentsPath + "/categories", Handler: apiSetCategoryPolicyHandler, Summary: "Change the policy of a category of environments, recorded in the activity of its environments", Tag: tagEnvironments, Request: environments.CategoryPolicy{}, Response: environments.CategoryPolicy{}},
+		{Method: http.MethodGet, Path: apiEnvironmentsPath + "/templates", Handler: apiEnvTemplatesHandler, Summary: "Get all environment templates", Tag: tagEnvironments, Response: []environments.EnvTemplate{}},
+		{Method: http.MethodPost, Path: apiEnvironmentsPath + "/templates", Handler: apiNewEnvTemplateHandler, Summary: "Create an environment template, from the configuration of an environment when it is set", Tag: tagEnvironments, Request: APIEnvTemplateRequest{}, Response: environments.EnvTemplate{}},
+		{Method: http.MethodGet, Path: apiEnvironmentsPath + "/templates/{name}", Handler: apiEnvTemplateHandler, Summary: "Get one environment template", Tag: tagEnvironments, Response: environments.EnvTemplate{}},
+		{Method: http.MethodPost, Path: apiEnvironmentsPath + "/templates/{name}", Handler: apiUpdateEnvTemplateHandler, Summary: "Save a new version of an environment template", Tag: tagEnvironments, Request: APIEnvTemplateRequest{}, Response: environments.EnvTemplate{}},
+		{Method: http.MethodPost, Path: apiEnvironmentsPath + "/templates/{name}/delete", Handler: apiDeleteEnvTemplateHandler, Summary: "Delete an environment template, environments created from it are not changed", Tag: tagEnvironments, Response: types.ApiGenericResponse{}},
+		{Method: http.MethodGet, Path: apiEnvironmentsPath + "/templates/{name}/propagate", Handler: apiPropagateDiffsHandler, Summary: "Get what would change in each environment created from a template", Tag: tagEnvironments, Response: []environments.TemplateDiff{}},
+		{Method: http.MethodPost, Path: apiEnvironmentsPath + "/templates/{name}/propagate", Handler: apiPropagateHandler, Summary: "Update environments created from a template with its latest version", Tag: tagEnvironments, Request: types.ApiPropagateRequest{}, Response: []environments.TLSEnvironment{}},
+		{Method: http.MethodPost, Path: apiEnvironmentsPath + "/{env}/certificate", Handler: apiCertificateHandler, Summary: "Stage a new certificate", Tag: tagEnvironments, Scope: users.ScopeEnvironmentsWrite, Request: types.ApiCertificateRequest{}, Response: types.ApiGenericResponse{}},
+		{Method: http.MethodGet, Path: apiEnvironmentsPath + "/{env}/certificate/status", Handler: apiCertificateStatusHandler, Summary: "Get the propagation of the certificate", Tag: tagEnvironments, Scope: users.ScopeEnvironmentsRead, Response: environments.CertificateStatus{}},
+		{Method: http.MethodGet, Path: apiEnvironmentsPath + "/{env}/enroll-fields", Handler: apiEnrollFieldsHandler, Summary: "Get the enroll metadata fields of an environment", Tag: tagEnvironments, Scope: users.ScopeEnvironmentsRead, Response: []environments.EnrollField{}},
+		{Method: http.MethodPost, Path: apiEnvironmentsPath + "/{env}/enroll-fields", Handler: apiSetEnrollFieldHandler, Summary: "Create or update an enroll metadata field", Tag: tagEnvironments, Scope: users.ScopeEnvironmentsWrite, Request: types.ApiEnrollFieldRequest{}, Response: environments.EnrollField{}},
+		{Method: http.MethodPost, Path: apiEnvironmentsPath + "/{env}/enroll-fields/delete", Handler: apiDeleteEnrollFieldHandler, Summary: "Delete an enroll metadata field by name, values stored in nodes are kept", Tag: tagEnvironments, Scope: users.ScopeEnvironmentsWrite, Request: types.ApiEnrollFieldRequest{}, Response: types.ApiGenericResponse{}},
+		{Method: http.MethodGet, Path: apiEnvironmentsPath + "/{env}/profiles", Handler: apiProfilesHandler, Summary: "Get the performance profiles of an environment", Tag: tagEnvironments, Scope: users.ScopeEnvironmentsRead, Response: []environments.PerformanceProfile{}},
+		{Method: http.MethodPost, Path: apiEnvironmentsPath + "/{env}/profiles", Handler: apiSetProfileHandler, Summary: "Create or update a performance profile with the check-in intervals of a class of nodes", Tag: tagEnvironments, Scope: users.ScopeEnvironmentsWrite, Request: types.ApiProfileRequest{}, Response: environments.PerformanceProfile{}},
+		{Method: http.MethodPost, Path: apiEnvironmentsPath + "/{env}/profiles/delete", Handler: apiDeleteProfileHandler, Summary: "Delete a performance profile by name, its nodes fall back to the environment intervals", Tag: tagEnvironments, Scope: users.ScopeEnvironmentsWrite, Request: types.ApiProfileRequest{}, Response: types.ApiGenericResponse{}},
+		{Method: http.MethodGet, Path: apiEnvironmentsPath + "/{env}/denylist", Handler: apiDenylistHandler, Summary: "Get the active scheduled query denylist of an environment", Tag: tagEnvironments, Scope: users.ScopeEnvironmentsRead, Response: []environments.ScheduleDeny{}},
+		{Method: http.MethodGet, Path: apiEnvironmentsPath + "/{env}/denylist/candidates", Handler: apiDenylistCandidatesHandler, Summary: "Get the scheduled queries, packs and pack queries in the configuration of an environment that can be denied", Tag: tagEnvironments, Scope: users.ScopeEnvironmentsRead, Response: []environments.DenyCandidate{}},
+		{Method: http.MethodPost, Path: apiEnvironmentsPath + "/{env}/denylist", Handler: apiAddDenyHandler, Summary: "Add a query or pack to the denylist, removing it from the configuration served to nodes wherever it is defined", Tag: tagEnvironments, Scope: users.ScopeEnvironmentsWrite, Request: types.ApiDenylistRequest{}, Response: environments.ScheduleDeny{}},
+		{Method: http.MethodPost, Path: apiEnvironmentsPath + "/{env}/denylist/lift", Handler: apiLiftDenyHandler, Summary: "Lift a denylist entry by ID before it expires", Tag: tagEnvironments, Scope: users.ScopeEnvironmentsWrite, Request: types.ApiDenylistRequest{}, Response: types.ApiGenericResponse{}},
+		{Method: http.MethodGet, Path: apiEnvironmentsPath + "/{env}/config/versions", Handler: apiConfigVersionsHandler, Summary: "Get the configuration versions of an environment, newest first", Tag: tagEnvironments, Scope: users.ScopeEnvironmentsRead, Response: []environments.ConfigVersion{}},
+		{Method: http.MethodGet, Path: apiEnvironmentsPath + "/{env}/config/conflicts", Handler: apiConfigConflictsHandler, Summary: "Get the conflicts between options, schedule and packs in the configuration of an environment, with their locations", Tag: tagEnvironments, Scope: users.ScopeEnvironmentsRead, Response: []environments.ConfigConflict{}},
+		{Method: http.MethodPost, Path: apiEnvironmentsPath + "/{env}/config/preview-impact", Handler: apiConfigImpactHandler, Summary: "Preview the active nodes by platform receiving a change of one section of the configuration, and the scheduled query executions per day it adds", Tag: tagEnvironments, Scope: users.ScopeEnvironmentsRead, Request: types.ApiConfigImpactRequest{}, Response: environments.ConfigImpact{}},
+		{Method: http.MethodPost, Path: apiEnvironmentsPath + "/{env}/config/verify", Handler: apiConfigVerifyHandler, Summary: "Verify the flags and configuration served against osquery versions, ?version= for one version or every version of the nodes, with ?platform= and ?profile= to select what is rendered. A change of one section can be sent to verify it before saving it", Tag: tagEnvironments, Scope: users.ScopeEnvironmentsRead, Request: types.ApiConfigImpactRequest{}, Response: environments.VerifyReport{}},
+		{Method: http.MethodPost, Path: apiEnvironmentsPath + "/{env}/config/rollback", Handler: apiConfigRollbackHandler, Summary: "Rollback the configuration of an environment to a version, saved as a new version", Tag: tagEnvironments, Scope: users.ScopeEnvironmentsWrite, Request: types.ApiRollbackRequest{}, Response: environments.ConfigVersion{}},
+		{Method: http.MethodGet, Path: apiEnvironmentsPath + "/{env}/config/scheduled", Handler: apiScheduledChangesHandler, Summary: "Get the latest scheduled changes of the configuration of an environment, newest first", Tag: tagEnvironments, Scope: users.ScopeEnvironmentsRead, Response: []environments.ScheduledChange{}},
+		{Method: http.MethodPost, Path: apiEnvironmentsPath + "/{env}/config/scheduled", Handler: apiScheduleConfigHandler, Summary: "Schedule a change of one section of the configuration to be applied at a time as a new version, a pending change is only replaced with replace set", Tag: tagEnvironments, Scope: users.ScopeEnvironmentsWrite, Request: types.ApiScheduleConfigRequest{}, Response: environments.ScheduledChange{}},
+		{Method: http.MethodPost, Path: apiEnvironmentsPath + "/{env}/config/scheduled/cancel", Handler: apiCancelScheduledHandler, Summary: "Cancel the pending scheduled change of the configuration of an environment", Tag: tagEnvironments, Scope: users.ScopeEnvironmentsWrite, Response: environments.ScheduledChange{}},
+		{Method: http.MethodGet, Path: apiEnvironmentsPath + "/{env}/packs/source", Handler: apiPackSourceHandler, Summary: "Get the Git repository the packs of an environment are synced from, with the synced packs and their conflicts", Tag: tagEnvironments, Scope: users.ScopeEnvironmentsRead, Response: environments.PackSyncStatus{}},
+		{Method: http.MethodPost, Path: apiEnvironmentsPath + "/{env}/packs/source", Handler: apiSetPackSourceHandler, Summary: "Create or update the Git repository the packs of an environment are synced from, with the credential as env:OSCTRL_PACK_NAME or file:name in the credentials directory", Tag: tagEnvironments, Scope: users.ScopeEnvironmentsWrite, Request: types.ApiPackSourceRequest{}, Response: environments.PackSource{}},
+		{Method: http.MethodPost, Path: apiEnvironmentsPath + "/{env}/packs/source/delete", Handler: apiDeletePackSourceHandler, Summary: "Delete the pack source of an environment, the synced packs are kept", Tag: tagEnvironments, Scope: users.ScopeEnvironmentsWrite, Response: types.ApiGenericResponse{}},
+		{Method: http.MethodPost, Path: apiEnvironmentsPath + "/{env}/packs/source/sync", Handler: apiSyncPacksHandler, Summary: "Sync the packs of an environment from its source in the background, without waiting for its interval", Tag: tagEnvironments, Scope: users.ScopeEnvironmentsWrite, Response: types.ApiGenericResponse{}},
+		{Method: http.MethodPost, Path: apiEnvironmentsPath + "/{env}/packs/source/resolve", Handler: apiResolvePackHandler, Summary: "Resolve the conflict of a synced pack edited manually, with repo or manual winning, empty to ask again", Tag: tagEnvironments, Scope: users.ScopeEnvironmentsWrite, Request: types.ApiPackResolveRequest{}, Response: types.ApiGenericResponse{}},
+		{Method: http.MethodGet, Path: apiEnvironmentsPath + "/{env}/windows-events", Handler: apiWindowsEventsHandler, Summary: "Get the Windows event channels of an environment with the flags, queries and warnings they generate", Tag: tagEnvironments, Scope: users.ScopeEnvironmentsRead, Response: environments.WindowsEventsPreview{}},
+		{Method: http.MethodPost, Path: apiEnvironmentsPath + "/{env}/windows-events", Handler: apiSetWindowsEventHandler, Summary: "Create, update, enable or disable a Windows event channel", Tag: tagEnvironments, Scope: users.ScopeEnvironmentsWrite, Request: types.ApiWindowsEventRequest{}, Response: environments.WindowsEventsPreview{}},
+		{Method: http.MethodPost, Path: apiEnvironmentsPath + "/{env}/windows-events/delete", Handler: apiDeleteWindowsEventHandler, Summary: "Delete a Windows event channel by name", Tag: tagEnvironments, Scope: users.ScopeEnvironmentsWrite, Request: types.ApiWindowsEventRequest{}, Response: types.ApiGenericResponse{}},
+		{Method: http.MethodGet, Path: apiEnvironmentsPath + "/{env}/windows-events/export", Handler: apiExportWindowsEventsHandler, Summary: "Export the Windows event channels of an environment", Tag: tagEnvironments, Scope: users.ScopeEnvironmentsRead, Response: []environments.WindowsEventSetting{}},
+		{Method: http.MethodPost, Path: apiEnvironmentsPath + "/{env}/windows-events/import", Handler: apiImportWindowsEventsHandler, Summary: "Replace all the Windows event channels of an environment with exported ones", Tag: tagEnvironments, Scope: users.ScopeEnvironmentsWrite, Request: []environments.WindowsEventSetting{}, Response: environments.WindowsEventsPreview{}},
+		{Method: http.MethodGet, Path: apiEnvironmentsPath + "/{env}/apikeys", Handler: apiKeysHandler, Summary: "Get the service keys of an environment with their usage", Tag: tagEnvironments, Response: []users.APIKey{}},
+		{Method: http.MethodPost, Path: apiEnvironmentsPath + "/{env}/apikeys", Handler: apiNewKeyHandler, Summary: "Create or rotate a service key, the key is only returned once", Tag: tagEnvironments, Request: types.ApiKeyRequest{}, Response: types.ApiKeyResponse{}},
+		{Method: http.MethodPost, Path: apiEnvironmentsPath + "/{env}/apikeys/revoke", Handler: apiRevokeKeyHandler, Summary: "Revoke a service key", Tag: tagEnvironments, Request: types.ApiKeyRequest{}, Response: types.ApiGenericResponse{}},
+		{Method: http.MethodGet, Path: apiEnvironmentsPath + "/{env}/activity", Handler: apiActivityHandler, Summary: "Get the activity of an environment, filtered with ?from=7d&to=&kind=&actor=&page=&limit=", Tag: tagEnvironments, Scope: users.ScopeEnvironmentsRead, Response: types.ApiActivityResponse{}},
+		{Method: http.MethodGet, Path: apiEnvironmentsPath + "/{env}/activity/{id}", Handler: apiActivityDiffHandler, Summary: "Get one entry of the activity of an environment with the differences", Tag: tagEnvironments, Scope: users.ScopeEnvironmentsRead, Response: APIActivityDiff{}},
+		{Method: http.MethodGet, Path: apiEnvironmentsPath + "/{env}/history", Handler: apiEnvHistoryHandler, Summary: "Get the daily node count history of an environment, filtered with ?metric=active&from=90d&to=", Tag: tagEnvironments, Scope: users.ScopeEnvironmentsRead, Response: []nodes.NodeCountSnapshot{}},
+		{Method: http.MethodGet, Path: apiEnvironmentsPath + "/{env}/storage", Handler: apiEnvStorageHandler, Summary: "Get the storage used by an environment by category, with its quota and the daily samples filtered with ?from=30d&to=", Tag: tagEnvironments, Scope: users.ScopeEnvironmentsRead, Response: environments.StorageReport{}},
+		{Method: http.MethodPost, Path: apiEnvironmentsPath + "/{env}/storage/quota", Handler: apiSetStorageQuotaHandler, Summary: "Set the storage quota of an environment in bytes, 0 removes it, and the actions while it is exceeded: notify, block_carves, pause_always_log", Tag: tagEnvironments, Scope: users.ScopeEnvironmentsWrite, Request: types.ApiStorageQuotaRequest{}, Response: environments.StorageQuota{}},
+		{Method: http.MethodGet, Path: apiEnvironmentsPath + "/{env}/enroll-loops", Handler: apiEnrollLoopsHandler, Summary: "Get the hosts of an environment enrolling in a loop, over the threshold of enrolls per hour, with hints at the cause from their latest enrolls", Tag: tagEnvironments, Scope: users.ScopeEnvironmentsRead, Response: nodes.EnrollLoopReport{}},
+		{Method: http.MethodGet, Path: apiEnvironmentsPath + "/{env}/coverage-estimate", Handler: apiCoverageEstimateHandler, Summary: "Estimate how many nodes are expected to check in within a window, from their check-ins of the last 7 days, with ?window=24h&targets=&platforms=", Tag: tagEnvironments, Scope: users.ScopeEnvironmentsRead, Response: nodes.CoverageEstimate{}},
+		{Method: http.MethodGet, Path: apiEnvironmentsPath + "/{env}/query-expiration", Handler: apiQueryExpirationHandler, Summary: "Get the default expiration of the queries of an environment and the range allowed to override it", Tag: tagEnvironments, Scope: users.ScopeEnvironmentsRead, Response: queries.ExpirationRange{}},
+		{Method: http.MethodPost, Path: apiEnvironmentsPath + "/{env}/query-expiration", Handler: apiSetQueryExpirationHandler, Summary: "Set the default expiration in hours of the queries of an environment, 0 for the global default", Tag: tagEnvironments, Scope: users.ScopeEnvironmentsWrite, Request: types.ApiQueryExpirationRequest{}, Response: queries.ExpirationRange{}},
+		{Method: http.MethodPost, Path: apiEnvironmentsPath + "/{env}/category", Handler: apiSetCategoryHandler, Summary: "Set the category of an environment: production, staging, development, lab or custom, empty to remove it", Tag: tagEnvironments, Scope: users.ScopeEnvironmentsWrite, Request: types.ApiCategoryRequest{}, Response: environments.TLSEnvironment{}},
+		{Method: http.MethodGet, Path: apiEnvironmentsPath + "/{env}/pseudonyms", Handler: apiPseudonymsHandler, Summary: "Get the columns and tables pseudonymized in the logs sent by an environment", Tag: tagEnvironments, Scope: users.ScopeEnvironmentsRead, Response: environments.PseudonymConfig{}},
+		{Method: http.MethodPost, Path: apiEnvironmentsPath + "/{env}/pseudonyms", Handler: apiSetPseudonymsHandler, Summary: "Set the columns and tables pseudonymized in the logs sent by an environment, as comma separated patterns like username,*_user, empty to disable it", Tag: tagEnvironments, Scope: users.ScopeEnvironmentsWrite, Request: types.ApiPseudonymsRequest{}, Response: environments.PseudonymConfig{}},
+		{Method: http.MethodGet, Path: apiEnvironmentsPath + "/{env}/pseudonyms/{pseudonym}", Handler: apiPseudonymLookupHandler, Summary: "Re-identify a pseudonym found in the logs of an environment, the lookup is recorded in its activity", Tag: tagEnvironments, Scope: users.ScopeEnvironmentsWrite, Response: types.ApiPseudonymLookup{}},
+		{Method: http.MethodGet, Path: apiEnvironmentsPath + "/{env}/quick-actions", Handler: apiQuickActionsHandler, Summary: "Get the quick actions of an environment", Tag: tagEnvironments, Scope: users.ScopeEnvironmentsRead, Response: []queries.QuickAction{}},
+		{Method: http.MethodPost, Path: apiEnvironmentsPath + "/{env}/quick-actions", Handler: apiSetQuickActionHandler, Summary: "Create or update a quick action of an environment as a new version: query of an approved template with preset variables, carve of a path, tag or accelerate", Tag: tagEnvironments, Scope: users.ScopeEnvironmentsWrite, Request: types.ApiQuickActionRequest{}, Response: queries.QuickAction{}},
+		{Method: http.MethodGet, Path: apiEnvironmentsPath + "/{env}/quick-actions/{name}/versions", Handler: apiQuickActionVersionsHandler, Summary: "Get all the versions of a quick action, also after it was deleted", Tag: tagEnvironments, Scope: users.ScopeEnvironmentsRead, Response: []queries.QuickActionVersion{}},
+		{Method: http.MethodPost, Path: apiEnvironmentsPath + "/{env}/quick-actions/{name}/delete", Handler: apiDeleteQuickActionHandler, Summary: "Delete a quick action, its versions are kept", Tag: tagEnvironments, Scope: users.ScopeEnvironmentsWrite, Response: types.ApiGenericResponse{}},
+		{Method: http.MethodPost, Path: apiEnvironmentsPath + "/{env}/quick-actions/{name}/run", Handler: apiRunQuickActionHandler, Summary: "Run a quick action against nodes of the environment, or preview what would run. Every run is recorded in the activity of the environment", Tag: tagQueries, Scope: users.ScopeActionsWrite, Request: types.ApiQuickActionRunRequest{}, Response: types.ApiQuickActionRunResponse{}},
+		{Method: http.MethodGet, Path: apiEnvironmentsPath + "/{env}/platforms", Handler: apiEnvPlatformsHandler, Summary: "Get the platforms of an environment", Tag: tagPlatforms, Scope: users.ScopeEnvironmentsRead, Response: []string{}},
+		{Method: http.MethodGet, Path: apiEnvironmentsPath + "/{env}", Handler: apiEnvironmentHandler, Summary: "Get one environment", Tag: tagEnvironments, Scope: users.ScopeEnvironmentsRead, Response: environments.TLSEnvironment{}},
+		{Method: http.MethodGet, Path: apiEnvironmentsPath, Handler: apiEnvironmentsHandler, Summary: "Get all environments, filtered by category with ?category=", Tag: tagEnvironments, Response: []environments.TLSEnvironment{}, Cached: true},
+		{Method: http.MethodPost, Path: apiEnvironmentsPath, Handler: apiNewEnvironmentHandler, Summary: "Create an environment from a template", Tag: tagEnvironments, Request: types.ApiEnvironmentRequest{}, Response: environments.TLSEnvironment{}},
+		// API: tags
+		{Method: http.MethodGet, Path: apiTrashPath + "/{env}", Handler: apiTrashHandler, Summary: "Get the queries, carves, templates, tags and own saved queries in the trash, newest first", Tag: tagTrash, Response: []backend.TrashItem{}},
+		{Method: http.MethodPost, Path: apiTrashPath + "/{env}/restore", Handler: apiTrashRestoreHandler, Summary: "Restore an item from the trash by kind and name", Tag: tagTrash, Request: types.ApiTrashRequest{}, Response: types.ApiGenericResponse{}},
+		{Method: http.MethodGet, Path: apiTagsPath, Handler: apiTagsHandler, Summary: "Get all tags", Tag: tagTags, Response: []tags.AdminTag{}},
+		{Method: http.MethodGet, Path: apiTagsPath + "/jobs/{id}", Handler: apiTagJobHandler, Summary: "Get the progress and counts of a job tagging nodes", Tag: tagTags, Response: tags.TagJob{}},
+		// API: reports
+		{Method: http.MethodGet, Path: apiReportsPath, Handler: apiReportsHandler, Summary: "Get all reports", Tag: tagReports, Response: []reports.Report{}},
+		{Method: http.MethodPost, Path: apiReportsPath, Handler: apiReportGenerateHandler, Summary: "Generate a new report", Tag: tagReports, Request: types.ApiReportRequest{}, Response: reports.Report{}},
+		{Method: http.MethodGet, Path: apiReportsPath + "/{id}/download", Handler: apiReportDownloadHandler, Summary: "Download a generated report", Tag: tagReports, ContentType: "application/octet-stream"},
+		{Method: http.MethodGet, Path: apiAccessLogPath, Handler: apiAccessLogHandler, Summary: "Get who downloaded carves, query results and node data, filtered with ?env=&user=&entity_type=&entity=&from=7d&to=&page=&limit=, or ?format=csv to export them, which is also recorded", Tag: tagReports, Response: APIAccessLogResponse{}},
+		// API: settings
+		{Method: http.MethodGet, Path: apiSettingsPath, Handler: apiSettingsHandler, Summary: "Get all settings", Tag: tagSettings, Response: []settings.SettingValue{}},
+		{Method: http.MethodGet, Path: apiSettingsPath + "/{service}", Handler: apiSettingsServiceHandler, Summary: "Get the settings of a service", Tag: tagSettings, Response: []settings.SettingValue{}},
+		{Method: http.MethodGet, Path: apiSettingsPath + "/{service}/json", Handler: apiSettingsServiceJSONHandler, Summary: "Get the JSON settings of a service", Tag: tagSettings, Response: []settings.SettingValue{}},
+	}
+}
+
+// Helper to register the routes of the API, with and without trailing slash
+func registerAPIRoutes(router *mux.Router, routes []apiRoute) {
+	for _, route := range routes {
+		var handler http.Handler = route.Handler
+		if !route.Public {
+			if group, ok := responseGroups[route.Tag]; ok {
+				if route.Cached {
+					handler = handlerResponseCache(handler, group)
+				} else if idempotentMethods[route.Method] {
+					handler = handlerResponseInvalidate(handler, group)
+				}
+			}
+			if kind, ok := eventKinds[route.Tag]; ok && idempotentMethods[route.Method] {
+				handler = handlerPublishEvent(handler, kind)
+			}
+			if idempotentMethods[route.Method] {
+				handler = handlerIdempotency(handler)
+			}
+			handler = handlerAuthCheck(handler, route.Scope)
+		}
+		router.Handle(_apiPath(route.Path), handler).Methods(route.Method)
+		router.Handle(_apiPath(route.Path)+"/", handler).Methods(route.Method)
+	}
+}
+
+// Helper to create the router for the API, with all the routes
+func createRouterAPI() *mux.Router {
+	routerAPI := mux.NewRouter()
+	// API: root
+	routerAPI.HandleFunc("/", rootHTTPHandler)
+	// API: testing
+	routerAPI.HandleFunc(healthPath, healthHTTPHandler).Methods("GET")
+	// API: version and build
+	routerAPI.HandleFunc(versionPath, versionHTTPHandler).Methods("GET")
+	// API: error
+	routerAPI.HandleFunc(errorPath, errorHTTPHandler).Methods("GET")
+	// API: forbidden
+	routerAPI.HandleFunc(forbiddenPath, forbiddenHTTPHandler).Methods("GET")
+	// API: all documented routes
+	registerAPIRoutes(routerAPI, apiRoutes())
+	return routerAPI
+}
@@ -0,0 +1,876 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/jmpsec/osctrl/backend"
+	"github.com/jmpsec/osctrl/cache"
+	"github.com/jmpsec/osctrl/carves"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/logging"
+	"github.com/jmpsec/osctrl/metrics"
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/jmpsec/osctrl/queries"
+	"github.com/jmpsec/osctrl/reports"
+	"github.com/jmpsec/osctrl/responses"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/tags"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+	"github.com/jmpsec/osctrl/version"
+	"github.com/jmpsec/osctrl/webhooks"
+	"github.com/urfave/cli/v2"
+	"osctrl/service"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	// Project name
+	projectName = "osctrl"
+	// Service name
+	serviceName = projectName + "-" + settings.ServiceAPI
+	// Service version
+	serviceVersion = version.OsctrlVersion
+	// Service description
+	serviceDescription = "API service for osctrl"
+	// Application description
+	appDescription = serviceDescription + ", a fast and efficient osquery management"
+	// Default service configuration file
+	defConfigurationFile = "config/" + settings.ServiceAPI + ".json"
+	// Default DB configuration file
+	defDBConfigurationFile = "config/db.json"
+	// Default redis configuration file
+	defRedisConfigurationFile = "config/redis.json"
+	// Default TLS certificate file
+	defTLSCertificateFile = "config/tls.crt"
+	// Default TLS private key file
+	defTLSKeyFile = "config/tls.key"
+	// Default JWT configuration file
+	defJWTConfigurationFile = "config/jwt.json"
+	// Default refreshing interval in seconds
+	defaultRefresh int = 300
+)
+
+// Paths
+const (
+	// HTTP health path
+	healthPath = "/health"
+	// HTTP path for the version and build of the service
+	versionPath = "/version"
+	// HTTP errors path
+	errorPath     = "/error"
+	forbiddenPath = "/forbidden"
+	// API prefix path
+	apiPrefixPath = "/api"
+	// API version path
+	apiVersionPath = "/v1"
+	// API login path
+	apiLoginPath = "/login"
+	// API nodes path
+	apiNodesPath = "/nodes"
+	// API queries path
+	apiQueriesPath = "/queries"
+	// API users path
+	apiUsersPath = "/users"
+	// API all queries path
+	apiAllQueriesPath = "/all-queries"
+	// API carves path
+	apiCarvesPath = "/carves"
+	// API platforms path
+	apiPlatformsPath = "/platforms"
+	// API environments path
+	apiEnvironmentsPath = "/environments"
+	// API tags path
+	apiTagsPath = "/tags"
+	// API settings path
+	apiSettingsPath = "/settings"
+	// API reports path
+	apiReportsPath = "/reports"
+	// API results path
+	apiResultsPath = "/results"
+	// API query templates path
+	apiTemplatesPath = "/templates"
+	// API OpenAPI spec path
+	apiOpenAPIPath = "/openapi.json"
+	// API logs path
+	apiLogsPath = "/logs"
+	// API response actions path
+	apiResponsesPath = "/responses"
+	// API statistics path
+	apiStatsPath = "/stats"
+	// API trash path
+	apiTrashPath = "/trash"
+	// API sharing exports path
+	apiSharesPath = "/shares"
+	// API notices path
+	apiNoticesPath = "/notices"
+	// API export jobs path
+	apiExportsPath = "/exports"
+	// API webhooks path
+	apiWebhooksPath = "/webhooks"
+	// API data access log path
+	apiAccessLogPath = "/access-log"
+)
+
+var (
+	// Wait for pending requests when the service shuts down
+	shutdownTimeout = 30 * time.Second
+)
+
+// Global variables
+var (
+	err          error
+	apiConfig    types.JSONConfigurationAPI
+	dbConfig     backend.JSONConfigurationDB
+	redisConfig  cache.JSONConfigurationRedis
+	jwtConfig    types.JSONConfigurationJWT
+	db           *backend.DBManager
+	redis        *cache.RedisManager
+	idempotency  idempotencyStore
+	readCache    responseStore
+	eventBus     *cache.EventBus
+	throttle     *users.LoginThrottle
+	apiUsers     *users.UserManager
+	tagsmgr      *tags.TagManager
+	settingsmgr  *settings.Settings
+	envs         *environments.Environment
+	envsmap      environments.MapEnvironments
+	settingsmap  settings.MapSettings
+	nodesmgr     *nodes.NodeManager
+	queriesmgr   *queries.Queries
+	filecarves   *carves.Carves
+	responsesmgr *responses.ResponseManager
+	webhooksmgr  *webhooks.WebhookManager
+	reportsmgr   *reports.ReportManager
+	deadletters  *logging.DeadLetters
+	exportStore  queries.ExportStore
+	archiveStore queries.ExportStore
+	_metrics     *metrics.Metrics
+	app          *cli.App
+	flags        []cli.Flag
+)
+
+// Variables for flags
+var (
+	configFlag          bool
+	serviceConfigFile   string
+	redisConfigFile     string
+	dbFlag              bool
+	redisFlag           bool
+	dbConfigFile        string
+	loggerValue         string
+	jwtFlag             bool
+	jwtConfigFile       string
+	tlsServer           bool
+	tlsCertFile         string
+	tlsKeyFile          string
+	tlsProfile          string
+	tlsMinVersion       string
+	tlsMaxVersion       string
+	tlsCiphers          string
+	tlsCurves           string
+	tlsNoTickets        bool
+	reportsTemplate     string
+	reportsPDF          string
+	compressionDisabled bool
+	compressionMinSize  int
+	exportsDir          string
+	exportsS3File       string
+	archiveS3File       string
+	exportsTTL          time.Duration
+	exportsThreshold    int
+	exportsPerUser      int
+	statusTokenSecret   string
+)
+
+// Valid values for auth and logging in configuration
+var validAuth = map[string]bool{
+	settings.AuthNone: true,
+	settings.AuthJWT:  true,
+}
+
+// Function to load the configuration file and assign to variables
+func loadConfiguration(file, service string) (types.JSONConfigurationAPI, error) {
+	var cfg types.JSONConfigurationAPI
+	log.Printf("Loading %s", file)
+	// Load file and read config
+	viper.SetConfigFile(file)
+	if err := viper.ReadInConfig(); err != nil {
+		return cfg, err
+	}
+	// API values
+	apiRaw := viper.Sub(service)
+	if apiRaw == nil {
+		return cfg, fmt.Errorf("JSON key %s not found in %s", service, file)
+	}
+	if err := apiRaw.Unmarshal(&cfg); err != nil {
+		return cfg, err
+	}
+	// Check if values are valid
+	if !validAuth[cfg.Auth] {
+		return cfg, fmt.Errorf("Invalid auth method: '%s'", cfg.Auth)
+	}
+	// No errors!
+	return cfg, nil
+}
+
+// Initialization code
+func init() {
+	// Initialize CLI flags
+	flags = []cli.Flag{
+		&cli.BoolFlag{
+			Name:        "config",
+			Aliases:     []string{"c"},
+			Value:       false,
+			Usage:       "Provide service configuration via JSON file",
+			EnvVars:     []string{"SERVICE_CONFIG"},
+			Destination: &configFlag,
+		},
+		&cli.StringFlag{
+			Name:        "config-file",
+			Aliases:     []string{"C"},
+			Value:       defConfigurationFile,
+			Usage:       "Load service configuration from `FILE`",
+			EnvVars:     []string{"SERVICE_CONFIG_FILE"},
+			Destination: &serviceConfigFile,
+		},
+		&cli.StringFlag{
+			Name:        "listener",
+			Aliases:     []string{"l"},
+			Value:       "0.0.0.0",
+			Usage:       "Listener for the service",
+			EnvVars:     []string{"SERVICE_LISTENER"},
+			Destination: &apiConfig.Listener,
+		},
+		&cli.StringFlag{
+			Name:        "port",
+			Aliases:     []string{"p"},
+			Value:       "9002",
+			Usage:       "TCP port for the service",
+			EnvVars:     []string{"SERVICE_PORT"},
+			Destination: &apiConfig.Port,
+		},
+		&cli.StringFlag{
+			Name:        "auth",
+			Aliases:     []string{"A"},
+			Value:       settings.AuthNone,
+			Usage:       "Authentication mechanism for the service",
+			EnvVars:     []string{"SERVICE_AUTH"},
+			Destination: &apiConfig.Auth,
+		},
+		&cli.StringFlag{
+			Name:        "host",
+			Aliases:     []string{"H"},
+			Value:       "0.0.0.0",
+			Usage:       "Exposed hostname the service uses",
+			EnvVars:     []string{"SERVICE_HOST"},
+			Destination: &apiConfig.Host,
+		},
+		&cli.StringFlag{
+			Name:        "url-prefix",
+			Value:       "",
+			Usage:       "URL path prefix to serve the service under, when it runs behind a reverse proxy",
+			EnvVars:     []string{"SERVICE_URL_PREFIX"},
+			Destination: &apiConfig.URLPrefix,
+		},
+		&cli.StringFlag{
+			Name:        "logging",
+			Aliases:     []string{"L"},
+			Value:       settings.LoggingNone,
+			Usage:       "Logging mechanism to handle logs from nodes",
+			EnvVars:     []string{"SERVICE_LOGGER"},
+			Destination: &loggerValue,
+		},
+		&cli.BoolFlag{
+			Name:        "redis",
+			Aliases:     []string{"r"},
+			Value:       false,
+			Usage:       "Provide redis configuration via JSON file",
+			EnvVars:     []string{"REDIS_CONFIG"},
+			Destination: &redisFlag,
+		},
+		&cli.StringFlag{
+			Name:        "redis-file",
+			Aliases:     []string{"R"},
+			Value:       defRedisConfigurationFile,
+			Usage:       "Load redis configuration from `FILE`",
+			EnvVars:     []string{"REDIS_CONFIG_FILE"},
+			Destination: &redisConfigFile,
+		},
+		&cli.StringFlag{
+			Name:        "redis-connection-string",
+			Value:       "",
+			Usage:       "Redis connection string, must include schema (<redis|rediss|unix>://<user>:<pass>@<host>:<port>/<db>?<options>",
+			EnvVars:     []string{"REDIS_CONNECTION_STRING"},
+			Destination: &redisConfig.ConnectionString,
+		},
+		&cli.StringFlag{
+			Name:        "redis-host",
+			Value:       "127.0.0.1",
+			Usage:       "Redis host to be connected to",
+			EnvVars:     []string{"REDIS_HOST"},
+			Destination: &redisConfig.Host,
+		},
+		&cli.StringFlag{
+			Name:        "redis-port",
+			Value:       "6379",
+			Usage:       "Redis port to be connected to",
+			EnvVars:     []string{"REDIS_PORT"},
+			Destination: &redisConfig.Port,
+		},
+		&cli.StringFlag{
+			Name:        "redis-pass",
+			Value:       "",
+			Usage:       "Password to be used for redis",
+			EnvVars:     []string{"REDIS_PASS"},
+			Destination: &redisConfig.Password,
+		},
+		&cli.IntFlag{
+			Name:        "redis-db",
+			Value:       0,
+			Usage:       "Redis database to be selected after connecting",
+			EnvVars:     []string{"REDIS_DB"},
+			Destination: &redisConfig.DB,
+		},
+		&cli.BoolFlag{
+			Name:        "db",
+			Aliases:     []string{"d"},
+			Value:       false,
+			Usage:       "Provide DB configuration via JSON file",
+			EnvVars:     []string{"DB_CONFIG"},
+			Destination: &dbFlag,
+		},
+		&cli.StringFlag{
+			Name:        "db-file",
+			Aliases:     []string{"D"},
+			Value:       defDBConfigurationFile,
+			Usage:       "Load DB configuration from `FILE`",
+			EnvVars:     []string{"DB_CONFIG_FILE"},
+			Destination: &dbConfigFile,
+		},
+		&cli.StringFlag{
+			Name:        "db-host",
+			Value:       "127.0.0.1",
+			Usage:       "Backend host to be connected to",
+			EnvVars:     []string{"DB_HOST"},
+			Destination: &dbConfig.Host,
+		},
+		&cli.StringFlag{
+			Name:        "db-port",
+			Value:       "5432",
+			Usage:       "Backend port to be connected to",
+			EnvVars:     []string{"DB_PORT"},
+			Destination: &dbConfig.Port,
+		},
+		&cli.StringFlag{
+			Name:        "db-name",
+			Value:       "osctrl",
+			Usage:       "Database name to be used in the backend",
+			EnvVars:     []string{"DB_NAME"},
+			Destination: &dbConfig.Name,
+		},
+		&cli.StringFlag{
+			Name:        "db-user",
+			Value:       "postgres",
+			Usage:       "Username to be used for the backend",
+			EnvVars:     []string{"DB_USER"},
+			Destination: &dbConfig.Username,
+		},
+		&cli.StringFlag{
+			Name:        "db-pass",
+			Value:       "postgres",
+			Usage:       "Password to be used for the backend",
+			EnvVars:     []string{"DB_PASS"},
+			Destination: &dbConfig.Password,
+		},
+		&cli.IntFlag{
+			Name:        "db-max-idle-conns",
+			Value:       20,
+			Usage:       "Maximum number of connections in the idle connection pool",
+			EnvVars:     []string{"DB_MAX_IDLE_CONNS"},
+			Destination: &dbConfig.MaxIdleConns,
+		},
+		&cli.IntFlag{
+			Name:        "db-max-open-conns",
+			Value:       100,
+			Usage:       "Maximum number of open connections to the database",
+			EnvVars:     []string{"DB_MAX_OPEN_CONNS"},
+			Destination: &dbConfig.MaxOpenConns,
+		},
+		&cli.IntFlag{
+			Name:        "db-conn-max-lifetime",
+			Value:       30,
+			Usage:       "Maximum amount of time a connection may be reused",
+			EnvVars:     []string{"DB_CONN_MAX_LIFETIME"},
+			Destination: &dbConfig.ConnMaxLifetime,
+		},
+		&cli.IntFlag{
+			Name:        "db-conn-max-idle-time",
+			Value:       0,
+			Usage:       "Maximum amount of time in seconds a connection may be idle, 0 to keep idle connections",
+			EnvVars:     []string{"DB_CONN_MAX_IDLE_TIME"},
+			Destination: &dbConfig.ConnMaxIdleTime,
+		},
+		&cli.IntFlag{
+			Name:        "db-instances",
+			Value:       1,
+			Usage:       "Expected number of instances of this service, to check if their connections fit in the database",
+			EnvVars:     []string{"DB_INSTANCES"},
+			Destination: &dbConfig.Instances,
+		},
+		&cli.BoolFlag{
+			Name:        "tls",
+			Aliases:     []string{"t"},
+			Value:       false,
+			Usage:       "Enable TLS termination. It requires certificate and key",
+			EnvVars:     []string{"TLS_SERVER"},
+			Destination: &tlsServer,
+		},
+		&cli.StringFlag{
+			Name:        "cert",
+			Aliases:     []string{"T"},
+			Value:       defTLSCertificateFile,
+			Usage:       "TLS termination certificate from `FILE`",
+			EnvVars:     []string{"TLS_CERTIFICATE"},
+			Destination: &tlsCertFile,
+		},
+		&cli.StringFlag{
+			Name:        "key",
+			Aliases:     []string{"K"},
+			Value:       defTLSKeyFile,
+			Usage:       "TLS termination private key from `FILE`",
+			EnvVars:     []string{"TLS_KEY"},
+			Destination: &tlsKeyFile,
+		},
+		&cli.StringFlag{
+			Name:        "tls-profile",
+			Value:       utils.DefaultTLSProfile,
+			Usage:       "TLS termination profile (legacy, modern, intermediate or fips)",
+			EnvVars:     []string{"TLS_PROFILE"},
+			Destination: &tlsProfile,
+		},
+		&cli.StringFlag{
+			Name:        "tls-min-version",
+			Value:       "",
+			Usage:       "Override the minimum TLS version of the profile (1.0, 1.1, 1.2 or 1.3)",
+			EnvVars:     []string{"TLS_MIN_VERSION"},
+			Destination: &tlsMinVersion,
+		},
+		&cli.StringFlag{
+			Name:        "tls-max-version",
+			Value:       "",
+			Usage:       "Override the maximum TLS version of the profile (1.0, 1.1, 1.2 or 1.3)",
+			EnvVars:     []string{"TLS_MAX_VERSION"},
+			Destination: &tlsMaxVersion,
+		},
+		&cli.StringFlag{
+			Name:        "tls-ciphers",
+			Value:       "",
+			Usage:       "Override the cipher suites of the profile, as comma separated list of Go names",
+			EnvVars:     []string{"TLS_CIPHERS"},
+			Destination: &tlsCiphers,
+		},
+		&cli.StringFlag{
+			Name:        "tls-curves",
+			Value:       "",
+			Usage:       "Override the curves of the profile, as comma separated list (X25519, P256, P384, P521)",
+			EnvVars:     []string{"TLS_CURVES"},
+			Destination: &tlsCurves,
+		},
+		&cli.BoolFlag{
+			Name:        "tls-disable-session-tickets",
+			Value:       false,
+			Usage:       "Disable TLS session tickets",
+			EnvVars:     []string{"TLS_DISABLE_SESSION_TICKETS"},
+			Destination: &tlsNoTickets,
+		},
+		&cli.BoolFlag{
+			Name:        "jwt",
+			Aliases:     []string{"j"},
+			Value:       false,
+			Usage:       "Provide JWT configuration via JSON file",
+			EnvVars:     []string{"JWT_CONFIG"},
+			Destination: &jwtFlag,
+		},
+		&cli.StringFlag{
+			Name:        "jwt-file",
+			Value:       defJWTConfigurationFile,
+			Usage:       "Load JWT configuration from `FILE`",
+			EnvVars:     []string{"JWT_CONFIG_FILE"},
+			Destination: &jwtConfigFile,
+		},
+		&cli.StringFlag{
+			Name:        "jwt-secret",
+			Usage:       "Password to be used for the backend",
+			EnvVars:     []string{"JWT_SECRET"},
+			Destination: &jwtConfig.JWTSecret,
+		},
+		&cli.IntFlag{
+			Name:        "jwt-expire",
+			Value:       3,
+			Usage:       "Maximum amount of hours for the tokens to expire",
+			EnvVars:     []string{"JWT_EXPIRE"},
+			Destination: &jwtConfig.HoursToExpire,
+		},
+		&cli.StringFlag{
+			Name:        "status-token-secret",
+			Value:       "",
+			Usage:       "Secret to sign node status tokens, the same for osctrl-admin and osctrl-api. Status tokens need it",
+			EnvVars:     []string{"STATUS_TOKEN_SECRET"},
+			Destination: &statusTokenSecret,
+		},
+		&cli.StringFlag{
+			Name:        "reports-template",
+			Value:       "",
+			Usage:       "Template file to render fleet reports, the default template is used if empty",
+			EnvVars:     []string{"REPORTS_TEMPLATE"},
+			Destination: &reportsTemplate,
+		},
+		&cli.StringFlag{
+			Name:        "reports-pdf",
+			Value:       "",
+			Usage:       "Command to convert reports to PDF, reading HTML from stdin and writing PDF to stdout (wkhtmltopdf --quiet - -)",
+			EnvVars:     []string{"REPORTS_PDF"},
+			Destination: &reportsPDF,
+		},
+		&cli.BoolFlag{
+			Name:        "disable-compression",
+			Value:       false,
+			Usage:       "Disable gzip/deflate compression of responses",
+			EnvVars:     []string{"DISABLE_COMPRESSION"},
+			Destination: &compressionDisabled,
+		},
+		&cli.IntFlag{
+			Name:        "compression-min-size",
+			Value:       utils.DefaultCompressMinSize,
+			Usage:       "Minimum size in bytes for responses to be compressed",
+			EnvVars:     []string{"COMPRESSION_MIN_SIZE"},
+			Destination: &compressionMinSize,
+		},
+		&cli.StringFlag{
+			Name:        "exports-dir",
+			Value:       filepath.Join(os.TempDir(), "osctrl-exports"),
+			Usage:       "Directory to materialize the exports of large results, they are kept in it unless S3 is used",
+			EnvVars:     []string{"EXPORTS_DIR"},
+			Destination: &exportsDir,
+		},
+		&cli.StringFlag{
+			Name:        "exports-s3-file",
+			Value:       "",
+			Usage:       "S3 configuration file to keep the exports of large results in a bucket, needed with several API instances",
+			EnvVars:     []string{"EXPORTS_S3_FILE"},
+			Destination: &exportsS3File,
+		},
+		&cli.DurationFlag{
+			Name:        "exports-ttl",
+			Value:       queries.DefaultExportTTL,
+			Usage:       "Time the exports of large results are kept to download them",
+			EnvVars:     []string{"EXPORTS_TTL"},
+			Destination: &exportsTTL,
+		},
+		&cli.IntFlag{
+			Name:        "exports-threshold",
+			Value:       queries.DefaultExportThreshold,
+			Usage:       "Rows of results from which exports are materialized to download them in ranges, 0 to stream them unless requested",
+			EnvVars:     []string{"EXPORTS_THRESHOLD"},
+			Destination: &exportsThreshold,
+		},
+		&cli.StringFlag{
+			Name:        "archive-s3-file",
+			Value:       "",
+			Usage:       "S3 configuration file to keep the archived results of old queries in a bucket, the one of exports is used if empty",
+			EnvVars:     []string{"ARCHIVE_S3_FILE"},
+			Destination: &archiveS3File,
+		},
+		&cli.IntFlag{
+			Name:        "exports-per-user",
+			Value:       queries.DefaultExportJobsPerUser,
+			Usage:       "Exports that one user can have materializing at once, 0 for no limit",
+			EnvVars:     []string{"EXPORTS_PER_USER"},
+			Destination: &exportsPerUser,
+		},
+	}
+	// Logging format flags
+	log.SetFlags(log.Lshortfile)
+}
+
+// Go go!
+func osctrlAPIService(ctx context.Context, shared *service.Shared) {
+	apiConfig.URLPrefix = utils.NormalizeURLPrefix(apiConfig.URLPrefix)
+	// Managers created by the service are stopped by it, shared ones by the process that created them
+	owned := shared == nil
+	if owned {
+		if shared, err = CreateShared(); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+	db = shared.DB
+	redis = shared.Redis
+	idempotency = redis
+	readCache = redis
+	// Connection pools of the database, checked for exhaustion and published for the stats API
+	instanceHost, _ := os.Hostname()
+	if owned {
+		shared.StartPoolMonitor(ctx, instanceHost+":"+apiConfig.Port, settings.ServiceAPI, nil)
+	}
+	// Events to notify osctrl-tls instances of changes right away
+	eventBus = cache.NewEventBus(instanceHost+":"+apiConfig.Port, &cache.RedisEvents{Redis: redis})
+	log.Println("Initialize users")
+	apiUsers = users.CreateUserManager(db.Conn, &jwtConfig)
+	tagsmgr = shared.Tags
+	if err := backend.MigrateTrash(db.Conn); err != nil {
+		log.Fatalf("%v", err)
+	}
+	envs = shared.Envs
+	settingsmgr = shared.Settings
+	// Delays and lockouts of failed logins and tokens, shared with osctrl-admin through redis
+	throttle = &users.LoginThrottle{
+		Counters: redis,
+		Lockouts: apiUsers,
+		Policy: func() users.LoginPolicy {
+			return users.NewLoginPolicy(settingsmgr.LoginMaxFailures(), settingsmgr.LoginLockoutMinutes())
+		},
+		Notify: func(lockout users.LoginLockout) {
+			go users.NotifyLockout(settingsmgr.LoginLockoutWebhook(), settings.ServiceAPI, lockout)
+		},
+	}
+	nodesmgr = shared.Nodes
+	queriesmgr = shared.Queries
+	filecarves = shared.Carves
+	responsesmgr = shared.Responses
+	webhooksmgr = shared.Webhooks
+	log.Println("Initialize reports")
+	reportsmgr = reports.CreateReports(db.Conn)
+	reportsmgr.TemplateFile = reportsTemplate
+	reportsmgr.PDFConverter = reportsPDF
+	// Initialize dead letters of failed log deliveries, replayed by osctrl-tls
+	deadletters = shared.DeadLetters
+	log.Println("Initialize exports")
+	if exportsS3File != "" {
+		s3Exports, err := carves.CreateCarverS3File(exportsS3File)
+		if err != nil {
+			log.Fatalf("Failed to initialize S3 for exports - %v", err)
+		}
+		exportStore, err = queries.CreateS3ExportStore(s3Exports.Client, s3Exports.S3Config.Bucket, "exports", exportsDir)
+		if err != nil {
+			log.Fatalf("Failed to initialize exports - %v", err)
+		}
+	} else {
+		exportStore, err = queries.CreateLocalExportStore(exportsDir)
+		if err != nil {
+			log.Fatalf("Failed to initialize exports - %v", err)
+		}
+	}
+	go cleanupExports(ctx)
+	log.Println("Initialize archives")
+	if archiveS3File == "" {
+		archiveS3File = exportsS3File
+	}
+	if archiveS3File != "" {
+		s3Archives, err := carves.CreateCarverS3File(archiveS3File)
+		if err != nil {
+			log.Fatalf("Failed to initialize S3 for archives - %v", err)
+		}
+		archiveStore, err = queries.CreateS3ExportStore(s3Archives.Client, s3Archives.S3Config.Bucket, "archives", exportsDir)
+		if err != nil {
+			log.Fatalf("Failed to initialize archives - %v", err)
+		}
+	} else {
+		archiveStore, err = queries.CreateLocalExportStore(filepath.Join(exportsDir, "archives"))
+		if err != nil {
+			log.Fatalf("Failed to initialize archives - %v", err)
+		}
+	}
+	archiveLeader := backend.CreateLeaderLock(db.Conn, "query-archive", 2*queries.DefaultArchiveInterval)
+	queriesmgr.StartArchiving(ctx, archiveLeader, queries.DefaultArchiveInterval, archiveStore, apiArchivePolicy, recordArchive)
+	log.Println("Loading service settings")
+	loadingSettings()
+
+	// Ticker to reload environments
+	// FIXME Implement Redis cache
+	// FIXME splay this?
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Println("DebugService: Environments ticker")
+	}
+	// Refresh environments as soon as service starts
+	go func() {
+		_t := settingsmgr.RefreshEnvs(settings.ServiceAPI)
+		if _t == 0 {
+			_t = int64(defaultRefresh)
+		}
+		for {
+			envsmap = refreshEnvironments()
+			time.Sleep(time.Duration(_t) * time.Second)
+		}
+	}()
+
+	// Ticker to reload settings
+	// FIXME Implement Redis cache
+	// FIXME splay this?
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Println("DebugService: Settings ticker")
+	}
+	// Refresh settings as soon as the service starts
+	go func() {
+		_t := settingsmgr.RefreshSettings(settings.ServiceAPI)
+		if _t == 0 {
+			_t = int64(defaultRefresh)
+		}
+		for {
+			settingsmap = refreshSettings()
+			time.Sleep(time.Duration(_t) * time.Second)
+		}
+	}()
+
+	// ///////////////////////// API
+	if settingsmgr.DebugService(settings.ServiceAPI) {
+		log.Println("DebugService: Creating router")
+	}
+	// Create router for API endpoint
+	routerAPI := createRouterAPI()
+	// Serve under the URL path prefix, if any, and compress responses, unless it is disabled
+	handlerAPI := utils.URLPrefixHandler(apiConfig.URLPrefix, routerAPI)
+	if !compressionDisabled {
+		handlerAPI = utils.CompressHandler(handlerAPI, utils.CompressConfig{MinSize: compressionMinSize})
+	}
+
+	// Launch listeners for API server
+	serviceListener := apiConfig.Listener + ":" + apiConfig.Port
+	srv := &http.Server{
+		Addr:    serviceListener,
+		Handler: handlerAPI,
+	}
+	// Stop gracefully, finishing pending requests
+	idleConnsClosed := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		log.Println("Shutting down service...")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("error shutting down HTTP server %v", err)
+		}
+		if owned {
+			if err := shared.Stop(shutdownTimeout); err != nil {
+				log.Printf("%v", err)
+			}
+		}
+		close(idleConnsClosed)
+	}()
+	if tlsServer {
+		cfg, err := utils.TLSConfig(utils.TLSOptions{
+			Profile:               tlsProfile,
+			MinVersion:            tlsMinVersion,
+			MaxVersion:            tlsMaxVersion,
+			CipherSuites:          splitValues(tlsCiphers),
+			Curves:                splitValues(tlsCurves),
+			DisableSessionTickets: tlsNoTickets,
+		})
+		if err != nil {
+			log.Fatalf("Invalid TLS configuration - %v", err)
+		}
+		log.Printf("TLS %s", utils.TLSSummary(tlsProfile, cfg))
+		srv.TLSConfig = cfg
+		srv.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler), 0)
+		log.Printf("%s - HTTPS listening %s", version.Info(serviceName), serviceListener)
+		if err := srv.ListenAndServeTLS(tlsCertFile, tlsKeyFile); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	} else {
+		log.Printf("%s - HTTP listening %s", version.Info(serviceName), serviceListener)
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}
+	<-idleConnsClosed
+}
+
+// Action to run when no flags are provided to run checks and prepare data
+func cliAction(c *cli.Context) error {
+	// Load configuration if external JSON config file is used
+	if configFlag {
+		apiConfig, err = loadConfiguration(serviceConfigFile, settings.ServiceAPI)
+		if err != nil {
+			return fmt.Errorf("Failed to load service configuration %s - %s", serviceConfigFile, err)
+		}
+	}
+	// Load DB configuration if external JSON config file is used
+	if dbFlag {
+		dbConfig, err = backend.LoadConfiguration(dbConfigFile, backend.DBKey)
+		if err != nil {
+			return fmt.Errorf("Failed to load DB configuration - %v", err)
+		}
+	}
+	// Load redis configuration if external JSON config file is used
+	if redisFlag {
+		redisConfig, err = cache.LoadConfiguration(redisConfigFile, cache.RedisKey)
+		if err != nil {
+			return fmt.Errorf("Failed to load redis configuration - %v", err)
+		}
+	}
+	// Load JWT configuration if external JWT JSON config file is used
+	if jwtFlag {
+		jwtConfig, err = loadJWTConfiguration(jwtConfigFile)
+		if err != nil {
+			return fmt.Errorf("Failed to load JWT configuration - %v", err)
+		}
+	}
+	return nil
+}
+
+// CreateShared to initialize the managers with the configuration of this service, to share them with
+// the other services running in the same process
+func CreateShared() (*service.Shared, error) {
+	return service.CreateShared(dbConfig, redisConfig, apiConfig.Carver, nil)
+}
+
+// Configure to parse the arguments of the service and load its configuration
+func Configure(args []string) error {
+	// Initiate CLI and parse arguments
+	app = cli.NewApp()
+	app.Name = serviceName
+	app.Usage = appDescription
+	app.Version = serviceVersion
+	app.Description = appDescription
+	app.Flags = flags
+	// Define this command for help to exit when help flag is passed
+	app.Commands = []*cli.Command{
+		{
+			Name: "help",
+			Action: func(c *cli.Context) error {
+				cli.ShowAppHelpAndExit(c, 0)
+				return nil
+			},
+		},
+	}
+	app.Action = cliAction
+	return app.Run(args)
+}
+
+// Run to start the service until the context is done, with the managers shared by other services
+// in the same process, or with its own managers if shared is nil
+func Run(ctx context.Context, shared *service.Shared) {
+	osctrlAPIService(ctx, shared)
+}
+
+// Main to run the service as osctrl-api
+func Main() {
+	if err := Configure(os.Args); err != nil {
+		log.Fatal(err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// Service starts!
+	osctrlAPIService(ctx, nil)
+}
@@ -1,8 +1,9 @@
-package main
+package server
 
 import (
 	"log"
 
+	"github.com/jmpsec/osctrl/cache"
 	"github.com/jmpsec/osctrl/metrics"
 	"github.com/jmpsec/osctrl/settings"
 )
@@ -81,6 +82,12 @@ func loadingSettings() {
 			log.Fatalf("Failed to add %s to settings: %v", settings.RefreshSettings, err)
 		}
 	}
+	// Check if service settings for idempotency keys is ready
+	if !settingsmgr.IsValue(settings.ServiceAPI, settings.IdempotencyHours) {
+		if err := settingsmgr.NewIntegerValue(settings.ServiceAPI, settings.IdempotencyHours, int64(cache.IdempotencyHours)); err != nil {
+			log.Fatalf("Failed to add %s to settings: %v", settings.IdempotencyHours, err)
+		}
+	}
 	// Metrics
 	loadingMetrics()
 	// Write JSON config to settings
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jmpsec/osctrl/admin/handlers"
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusTokenSharedSecret(t *testing.T) {
+	previousSecret, previousUsers := statusTokenSecret, apiUsers
+	defer func() { statusTokenSecret, apiUsers = previousSecret, previousUsers }()
+	// Each service has its own JWT secret, status tokens use the secret shared by both
+	statusTokenSecret = "shared-secret"
+	apiUsers = &users.UserManager{JWTConfig: &types.JSONConfigurationJWT{JWTSecret: "api-jwt-secret"}}
+	admin := handlers.CreateHandlersAdmin(
+		handlers.WithUsers(&users.UserManager{JWTConfig: &types.JSONConfigurationJWT{JWTSecret: "admin-jwt-secret"}}),
+		handlers.WithStatusTokenSecret("shared-secret"),
+	)
+	maxLifetime := 24 * time.Hour
+	now := time.Now()
+	// Generated by osctrl-api and verified by osctrl-admin
+	token, err := nodes.NewStatusToken(statusTokenKey(), "env-uuid", "node-uuid", now, now.Add(time.Hour))
+	assert.NoError(t, err)
+	env, node, ok := nodes.CheckStatusToken(admin.StatusTokenKey(), token, now, maxLifetime)
+	assert.True(t, ok)
+	assert.Equal(t, "env-uuid", env)
+	assert.Equal(t, "node-uuid", node)
+	// Generated by osctrl-admin and verified with the key of osctrl-api
+	token, err = nodes.NewStatusToken(admin.StatusTokenKey(), "env-uuid", "node-uuid", now, now.Add(time.Hour))
+	assert.NoError(t, err)
+	_, _, ok = nodes.CheckStatusToken(statusTokenKey(), token, now, maxLifetime)
+	assert.True(t, ok)
+	// Without the shared secret there are no status tokens
+	statusTokenSecret = ""
+	assert.Nil(t, statusTokenKey())
+	_, _, ok = nodes.CheckStatusToken(handlers.CreateHandlersAdmin().StatusTokenKey(), token, now, maxLifetime)
+	assert.False(t, ok)
+}
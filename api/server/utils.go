@@ -1,4 +1,4 @@
-package main
+package server
 
 import (
 	"flag"
@@ -6,6 +6,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strings"
 
 	"github.com/jmpsec/osctrl/environments"
 	"github.com/jmpsec/osctrl/settings"
@@ -98,3 +99,21 @@ func apiErrorResponse(w http.ResponseWriter, msg string, code int, err error) {
 	log.Printf("apiErrorResponse %s: %v", msg, err)
 	utils.HTTPResponse(w, utils.JSONApplicationUTF8, code, types.ApiErrorResponse{Error: msg})
 }
+
+// Helper to record activity of an environment with the values before and after, errors are only logged
+func recordActivity(env environments.TLSEnvironment, kind, actor, summary, before, after string) {
+	if err := envs.RecordActivity(env.ID, kind, actor, summary, before, after); err != nil {
+		log.Printf("error recording activity for %s %v", env.Name, err)
+	}
+}
+
+// Helper to split a comma separated list of values, ignoring empty ones
+func splitValues(list string) []string {
+	var values []string
+	for _, v := range strings.Split(list, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
@@ -19,9 +19,12 @@ const (
 
 // DBManager have access to backend
 type DBManager struct {
-	Conn   *gorm.DB
-	Config *JSONConfigurationDB
-	DSN    string
+	Conn     *gorm.DB
+	Config   *JSONConfigurationDB
+	DSN      string
+	Replicas []*DBReplica
+	next     uint32
+	primary  int64
 }
 
 // JSONConfigurationDB to hold all backend configuration values
@@ -34,6 +37,13 @@ type JSONConfigurationDB struct {
 	MaxIdleConns    int    `json:"max_idle_conns"`
 	MaxOpenConns    int    `json:"max_open_conns"`
 	ConnMaxLifetime int    `json:"conn_max_lifetime"`
+	ConnMaxIdleTime int    `json:"conn_max_idle_time"`
+	// Expected number of instances of the service, to check if their pools fit in max_connections
+	Instances int `json:"instances"`
+	// Read replicas as host:port, using the same name and credentials as the primary
+	Replicas []string `json:"replicas"`
+	// Maximum replication lag in seconds before a replica stops receiving reads, 0 means no limit
+	MaxReplicaLag int `json:"max_replica_lag"`
 }
 
 // LoadConfiguration to load the DB configuration file and assign to variables
@@ -61,7 +71,12 @@ func PrepareDSN(config JSONConfigurationDB) string {
 
 // GetDB to get PostgreSQL DB using GORM
 func (db *DBManager) GetDB() (*gorm.DB, error) {
-	dbConn, err := gorm.Open(postgres.Open(db.DSN), &gorm.Config{})
+	return db.openDB(db.DSN)
+}
+
+// Helper to open and configure one PostgreSQL connection using GORM
+func (db *DBManager) openDB(dsn string) (*gorm.DB, error) {
+	dbConn, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
 	if err != nil {
 		return nil, err
 	}
@@ -76,6 +91,7 @@ func (db *DBManager) GetDB() (*gorm.DB, error) {
 	sqlDB.SetMaxIdleConns(db.Config.MaxIdleConns)
 	sqlDB.SetMaxOpenConns(db.Config.MaxOpenConns)
 	sqlDB.SetConnMaxLifetime(time.Second * time.Duration(db.Config.ConnMaxLifetime))
+	sqlDB.SetConnMaxIdleTime(time.Second * time.Duration(db.Config.ConnMaxIdleTime))
 	return dbConn, nil
 }
 
@@ -110,5 +126,6 @@ func CreateDBManager(dbConfig JSONConfigurationDB) (*DBManager, error) {
 		return nil, fmt.Errorf("Failed to get DB - %v", err)
 	}
 	db.Conn = dbConn
+	db.prepareReplicas()
 	return db, nil
 }
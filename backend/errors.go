@@ -0,0 +1,152 @@
+package backend
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+
+	"gorm.io/gorm"
+)
+
+// ErrorKind to classify errors from the database
+type ErrorKind int
+
+// Kinds of database errors
+const (
+	// KindNone is used when there is no error
+	KindNone ErrorKind = iota
+	// KindTransient is for failures that may succeed if retried, like failovers or lost connections
+	KindTransient
+	// KindConstraint is for writes that violate a constraint of the schema
+	KindConstraint
+	// KindNotFound is for reads that did not find the record
+	KindNotFound
+	// KindPermanent is for anything else, retrying will not help
+	KindPermanent
+)
+
+// String to get the name of the kind of error, used for metrics
+func (k ErrorKind) String() string {
+	switch k {
+	case KindNone:
+		return "none"
+	case KindTransient:
+		return "transient"
+	case KindConstraint:
+		return "constraint"
+	case KindNotFound:
+		return "not-found"
+	}
+	return "permanent"
+}
+
+// DBError to wrap a database error with its kind and the operation that failed.
+// The message is the one from the wrapped error, so existing checks on it keep working.
+type DBError struct {
+	Kind ErrorKind
+	Op   string
+	Err  error
+}
+
+// Error to implement the error interface
+func (e *DBError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap to get the original error
+func (e *DBError) Unwrap() error {
+	return e.Err
+}
+
+// sqlStateError is implemented by the errors of the postgres drivers (pgx and pq)
+type sqlStateError interface {
+	SQLState() string
+}
+
+// SQLSTATE codes that are worth retrying, other than the connection exception class (08)
+// https://www.postgresql.org/docs/current/errcodes-appendix.html
+var transientStates = map[string]bool{
+	"40001": true, // serialization_failure
+	"40P01": true, // deadlock_detected
+	"53300": true, // too_many_connections
+	"57P01": true, // admin_shutdown
+	"57P02": true, // crash_shutdown
+	"57P03": true, // cannot_connect_now
+	"25006": true, // read_only_sql_transaction, writing to a primary that was just demoted
+}
+
+// Classify to get the kind of a database error
+func Classify(err error) ErrorKind {
+	if err == nil {
+		return KindNone
+	}
+	var dbErr *DBError
+	if errors.As(err, &dbErr) {
+		return dbErr.Kind
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return KindNotFound
+	}
+	// The caller is gone, retrying is pointless
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return KindPermanent
+	}
+	var stateErr sqlStateError
+	if errors.As(err, &stateErr) {
+		state := stateErr.SQLState()
+		switch {
+		case strings.HasPrefix(state, "08") || transientStates[state]:
+			return KindTransient
+		case strings.HasPrefix(state, "23"):
+			return KindConstraint
+		}
+		return KindPermanent
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return KindTransient
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) {
+		return KindTransient
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return KindTransient
+	}
+	return KindPermanent
+}
+
+// IsTransient to check if an error may succeed if retried
+func IsTransient(err error) bool {
+	return Classify(err) == KindTransient
+}
+
+// IsNotFound to check if an error is because the record does not exist
+func IsNotFound(err error) bool {
+	return Classify(err) == KindNotFound
+}
+
+// IsConstraint to check if an error is a constraint violation
+func IsConstraint(err error) bool {
+	return Classify(err) == KindConstraint
+}
+
+// HTTPStatus to get the HTTP status code for a database error. Transient failures return 503,
+// so clients like osquery retry later instead of treating the request as invalid.
+func HTTPStatus(err error) int {
+	switch Classify(err) {
+	case KindNone:
+		return http.StatusOK
+	case KindTransient:
+		return http.StatusServiceUnavailable
+	case KindConstraint:
+		return http.StatusConflict
+	case KindNotFound:
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
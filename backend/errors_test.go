@@ -0,0 +1,121 @@
+package backend
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+var noDelay = RetryConfig{Attempts: 3}
+
+func TestClassify(t *testing.T) {
+	assert.Equal(t, KindNone, Classify(nil))
+	assert.Equal(t, KindNotFound, Classify(gorm.ErrRecordNotFound))
+	assert.Equal(t, KindNotFound, Classify(fmt.Errorf("First %w", gorm.ErrRecordNotFound)))
+	// Failover of the primary
+	assert.Equal(t, KindTransient, Classify(&pgconn.PgError{Code: "57P01"}))
+	assert.Equal(t, KindTransient, Classify(&pgconn.PgError{Code: "25006"}))
+	assert.Equal(t, KindTransient, Classify(&pgconn.PgError{Code: "08006"}))
+	assert.Equal(t, KindTransient, Classify(driver.ErrBadConn))
+	assert.Equal(t, KindTransient, Classify(fmt.Errorf("read %w", syscall.ECONNRESET)))
+	assert.Equal(t, KindConstraint, Classify(&pgconn.PgError{Code: "23505"}))
+	assert.Equal(t, KindPermanent, Classify(&pgconn.PgError{Code: "42P01"}))
+	assert.Equal(t, KindPermanent, Classify(context.Canceled))
+	assert.Equal(t, KindPermanent, Classify(errors.New("something else")))
+	assert.Equal(t, KindConstraint, Classify(&DBError{Kind: KindConstraint, Err: errors.New("wrapped")}))
+}
+
+func TestHTTPStatus(t *testing.T) {
+	assert.Equal(t, http.StatusOK, HTTPStatus(nil))
+	assert.Equal(t, http.StatusServiceUnavailable, HTTPStatus(&pgconn.PgError{Code: "57P01"}))
+	assert.Equal(t, http.StatusConflict, HTTPStatus(&pgconn.PgError{Code: "23505"}))
+	assert.Equal(t, http.StatusNotFound, HTTPStatus(gorm.ErrRecordNotFound))
+	assert.Equal(t, http.StatusInternalServerError, HTTPStatus(errors.New("something else")))
+}
+
+func TestDBErrorMessage(t *testing.T) {
+	err := &DBError{Kind: KindNotFound, Op: "test", Err: gorm.ErrRecordNotFound}
+	assert.Equal(t, "record not found", err.Error())
+	assert.True(t, errors.Is(err, gorm.ErrRecordNotFound))
+}
+
+func TestRetryRecovered(t *testing.T) {
+	calls := 0
+	err := RetryWith(context.Background(), noDelay, "test.recovered", func() error {
+		calls++
+		if calls == 1 {
+			return &pgconn.PgError{Code: "57P01"}
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, calls)
+	stats := GetRetryStats()["test.recovered"]
+	assert.Equal(t, int64(1), stats.Retries)
+	assert.Equal(t, int64(1), stats.Recovered)
+}
+
+func TestRetryExhausted(t *testing.T) {
+	calls := 0
+	err := RetryWith(context.Background(), noDelay, "test.exhausted", func() error {
+		calls++
+		return driver.ErrBadConn
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 3, calls)
+	assert.True(t, IsTransient(err))
+	assert.Equal(t, http.StatusServiceUnavailable, HTTPStatus(err))
+	stats := GetRetryStats()["test.exhausted"]
+	assert.Equal(t, int64(2), stats.Retries)
+	assert.Equal(t, int64(1), stats.Exhausted)
+}
+
+func TestRetryNotTransient(t *testing.T) {
+	calls := 0
+	err := RetryWith(context.Background(), noDelay, "test.permanent", func() error {
+		calls++
+		return &pgconn.PgError{Code: "23505"}
+	})
+	assert.True(t, IsConstraint(err))
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, int64(1), GetRetryStats()["test.permanent"].Failed)
+	// Not found is an expected result, it is not counted as a failure
+	calls = 0
+	err = RetryWith(context.Background(), noDelay, "test.notfound", func() error {
+		calls++
+		return gorm.ErrRecordNotFound
+	})
+	assert.True(t, IsNotFound(err))
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, int64(0), GetRetryStats()["test.notfound"].Failed)
+}
+
+func TestRetryCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls := 0
+	err := RetryWith(ctx, RetryConfig{Attempts: 3, Base: time.Second, Max: time.Second}, "test.cancelled", func() error {
+		calls++
+		return driver.ErrBadConn
+	})
+	assert.True(t, IsTransient(err))
+	assert.Equal(t, 1, calls)
+}
+
+func TestBackoff(t *testing.T) {
+	cfg := RetryConfig{Attempts: 5, Base: 10 * time.Millisecond, Max: 30 * time.Millisecond}
+	for retry := 1; retry < 5; retry++ {
+		delay := backoff(cfg, retry)
+		assert.True(t, delay > 0 && delay <= cfg.Max)
+	}
+	assert.Equal(t, time.Duration(0), backoff(noDelay, 1))
+}
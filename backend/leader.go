@@ -0,0 +1,76 @@
+package backend
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DefaultLeaderTTL is the default time a leader keeps the lock without renewing it
+const DefaultLeaderTTL = 10 * time.Minute
+
+// LeaderLease to keep which instance of a service holds a named lock, and until when
+type LeaderLease struct {
+	Name      string `gorm:"primaryKey"`
+	Holder    string
+	ExpiresAt time.Time
+}
+
+// LeaderLock to coordinate background jobs, so only one instance of a service runs them
+type LeaderLock struct {
+	DB     *gorm.DB
+	Name   string
+	Holder string
+	TTL    time.Duration
+}
+
+// CreateLeaderLock to initialize the leader lock and its table, the holder identifies this instance
+func CreateLeaderLock(backend *gorm.DB, name string, ttl time.Duration) *LeaderLock {
+	// table leader_leases
+	if err := backend.AutoMigrate(&LeaderLease{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (leader_leases): %v", err)
+	}
+	if ttl <= 0 {
+		ttl = DefaultLeaderTTL
+	}
+	hostname, _ := os.Hostname()
+	return &LeaderLock{
+		DB:     backend,
+		Name:   name,
+		Holder: fmt.Sprintf("%s-%d-%d", hostname, os.Getpid(), time.Now().UnixNano()),
+		TTL:    ttl,
+	}
+}
+
+// Acquire to get or renew the lock, returns true if this instance is the leader until the TTL
+func (l *LeaderLock) Acquire() (bool, error) {
+	now := time.Now()
+	lease := LeaderLease{Name: l.Name, Holder: l.Holder, ExpiresAt: now.Add(l.TTL)}
+	// The lease only changes hands when it belongs to this instance or it is expired
+	res := l.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}},
+		DoUpdates: clause.AssignmentColumns([]string{"holder", "expires_at"}),
+		Where: clause.Where{Exprs: []clause.Expression{
+			clause.Or(
+				clause.Eq{Column: clause.Column{Table: "leader_leases", Name: "holder"}, Value: l.Holder},
+				clause.Lt{Column: clause.Column{Table: "leader_leases", Name: "expires_at"}, Value: now},
+			),
+		}},
+	}).Create(&lease)
+	if res.Error != nil {
+		return false, fmt.Errorf("Acquire %v", res.Error)
+	}
+	return res.RowsAffected == 1, nil
+}
+
+// Release to give up the lock, if this instance holds it
+func (l *LeaderLock) Release() error {
+	if err := l.DB.Where("name = ? AND holder = ?", l.Name, l.Holder).Delete(&LeaderLease{}).Error; err != nil {
+		return fmt.Errorf("Release %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package backend
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestLeaderAcquire(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db, got error: %v", err)
+	}
+	defer mockDB.Close()
+	_postgres, err := gorm.Open(postgres.New(postgres.Config{Conn: mockDB}), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("unable to create new postgres database: %v", err)
+	}
+	l := &LeaderLock{DB: _postgres, Name: "history", Holder: "admin-1", TTL: time.Minute}
+	// Lease is free or already ours
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO "leader_leases" .* ON CONFLICT \("name"\) DO UPDATE SET "holder"="excluded"."holder","expires_at"="excluded"."expires_at" WHERE \("leader_leases"."holder" = \$4 OR "leader_leases"."expires_at" < \$5\)`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	leader, err := l.Acquire()
+	assert.NoError(t, err)
+	assert.True(t, leader)
+	// Lease held by another instance
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO "leader_leases"`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	leader, err = l.Acquire()
+	assert.NoError(t, err)
+	assert.False(t, leader)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,191 @@
+package backend
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultPoolCheck is the default interval to check the connection pools
+	DefaultPoolCheck = time.Minute
+	// DefaultPoolWaitThreshold is the average wait for a connection that indicates the pool is exhausted
+	DefaultPoolWaitThreshold = 100 * time.Millisecond
+	// PoolPrimary to identify the pool of the primary database
+	PoolPrimary = "primary"
+	// Query to get the connections allowed by the server and the ones reserved for superusers
+	maxConnectionsQuery = "SELECT current_setting('max_connections')::int AS max_connections, current_setting('superuser_reserved_connections')::int AS reserved"
+)
+
+// PoolStats to hold the statistics of one connection pool
+type PoolStats struct {
+	Pool              string        `json:"pool"`
+	MaxOpen           int           `json:"max_open"`
+	Open              int           `json:"open"`
+	InUse             int           `json:"in_use"`
+	Idle              int           `json:"idle"`
+	WaitCount         int64         `json:"wait_count"`
+	WaitDuration      time.Duration `json:"wait_duration"`
+	MaxIdleClosed     int64         `json:"max_idle_closed"`
+	MaxIdleTimeClosed int64         `json:"max_idle_time_closed"`
+	MaxLifetimeClosed int64         `json:"max_lifetime_closed"`
+}
+
+// InstancePoolStats to hold the connection pools of one instance of a service
+type InstancePoolStats struct {
+	Instance string      `json:"instance"`
+	Service  string      `json:"service"`
+	Pools    []PoolStats `json:"pools"`
+}
+
+// NewPoolStats to convert the statistics of a sql.DB into the statistics of a pool
+func NewPoolStats(pool string, s sql.DBStats) PoolStats {
+	return PoolStats{
+		Pool:              pool,
+		MaxOpen:           s.MaxOpenConnections,
+		Open:              s.OpenConnections,
+		InUse:             s.InUse,
+		Idle:              s.Idle,
+		WaitCount:         s.WaitCount,
+		WaitDuration:      s.WaitDuration,
+		MaxIdleClosed:     s.MaxIdleClosed,
+		MaxIdleTimeClosed: s.MaxIdleTimeClosed,
+		MaxLifetimeClosed: s.MaxLifetimeClosed,
+	}
+}
+
+// PoolStats to get the statistics of the pools of the primary and of the connected replicas
+func (db *DBManager) PoolStats() []PoolStats {
+	var stats []PoolStats
+	if sqlDB, err := db.Conn.DB(); err == nil {
+		stats = append(stats, NewPoolStats(PoolPrimary, sqlDB.Stats()))
+	}
+	for _, replica := range db.Replicas {
+		if replica.Conn == nil {
+			continue
+		}
+		if sqlDB, err := replica.Conn.DB(); err == nil {
+			stats = append(stats, NewPoolStats(replica.Address, sqlDB.Stats()))
+		}
+	}
+	return stats
+}
+
+// PoolMonitor to detect pools exhausted between checks, by the average wait for a connection
+type PoolMonitor struct {
+	Threshold time.Duration
+	last      map[string]PoolStats
+	mutex     sync.Mutex
+}
+
+// NewPoolMonitor to initialize the monitor of pools, with the default threshold if none is provided
+func NewPoolMonitor(threshold time.Duration) *PoolMonitor {
+	if threshold <= 0 {
+		threshold = DefaultPoolWaitThreshold
+	}
+	return &PoolMonitor{Threshold: threshold, last: make(map[string]PoolStats)}
+}
+
+// Check to get the pools that waited longer than the threshold on average since the previous check,
+// a warning is logged for each one of them
+func (m *PoolMonitor) Check(stats []PoolStats) []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var exhausted []string
+	for _, s := range stats {
+		last := m.last[s.Pool]
+		m.last[s.Pool] = s
+		// Counters start again when the pool is opened again
+		if s.WaitCount < last.WaitCount {
+			last = PoolStats{}
+		}
+		waits := s.WaitCount - last.WaitCount
+		if waits == 0 {
+			continue
+		}
+		avg := (s.WaitDuration - last.WaitDuration) / time.Duration(waits)
+		if avg <= m.Threshold {
+			continue
+		}
+		exhausted = append(exhausted, s.Pool)
+		log.Printf("WARNING DB pool exhausted pool=%s waits=%d avg_wait=%s threshold=%s in_use=%d max_open=%d", s.Pool, waits, avg, m.Threshold, s.InUse, s.MaxOpen)
+	}
+	return exhausted
+}
+
+// StartPoolMonitor to check the pools periodically until ctx is cancelled, the statistics are passed to
+// send after every check if it is provided
+func (db *DBManager) StartPoolMonitor(ctx context.Context, interval, threshold time.Duration, send func([]PoolStats)) {
+	if interval <= 0 {
+		interval = DefaultPoolCheck
+	}
+	monitor := NewPoolMonitor(threshold)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				stats := db.PoolStats()
+				monitor.Check(stats)
+				if send != nil {
+					send(stats)
+				}
+			}
+		}
+	}()
+}
+
+// PoolCapacity to compare the connections all instances can open with the ones allowed by the server
+type PoolCapacity struct {
+	Instances      int `json:"instances"`
+	MaxOpen        int `json:"max_open"`
+	Potential      int `json:"potential"`
+	MaxConnections int `json:"max_connections"`
+	Reserved       int `json:"reserved"`
+}
+
+// NewPoolCapacity to calculate the potential connections of all instances, at least one instance is expected
+func NewPoolCapacity(maxOpen, instances, maxConnections, reserved int) PoolCapacity {
+	if instances < 1 {
+		instances = 1
+	}
+	return PoolCapacity{
+		Instances:      instances,
+		MaxOpen:        maxOpen,
+		Potential:      maxOpen * instances,
+		MaxConnections: maxConnections,
+		Reserved:       reserved,
+	}
+}
+
+// Exceeded to check if the instances can open more connections than the server allows, a pool without limit always can
+func (c PoolCapacity) Exceeded() bool {
+	return c.MaxOpen <= 0 || c.Potential > c.MaxConnections-c.Reserved
+}
+
+// Suggested to get the maximum open connections of each instance that fits in the server
+func (c PoolCapacity) Suggested() int {
+	return (c.MaxConnections - c.Reserved) / c.Instances
+}
+
+// CheckPoolCapacity to check if the configured pools of all instances fit in the max_connections of the primary,
+// a warning with the suggested maximum open connections is logged when they do not
+func (db *DBManager) CheckPoolCapacity() (PoolCapacity, error) {
+	var server struct {
+		MaxConnections int
+		Reserved       int
+	}
+	if err := db.Conn.Raw(maxConnectionsQuery).Scan(&server).Error; err != nil {
+		return PoolCapacity{}, err
+	}
+	capacity := NewPoolCapacity(db.Config.MaxOpenConns, db.Config.Instances, server.MaxConnections, server.Reserved)
+	if capacity.Exceeded() {
+		log.Printf("WARNING DB pool may exceed max_connections instances=%d max_open=%d potential=%d max_connections=%d reserved=%d suggested_max_open=%d", capacity.Instances, capacity.MaxOpen, capacity.Potential, capacity.MaxConnections, capacity.Reserved, capacity.Suggested())
+	}
+	return capacity, nil
+}
@@ -0,0 +1,87 @@
+package backend
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Driver with connections that can not run statements, enough to exercise the pool
+type poolDriver struct{}
+
+type poolConn struct{}
+
+func (poolDriver) Open(name string) (driver.Conn, error) { return poolConn{}, nil }
+
+func (poolConn) Prepare(query string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+
+func (poolConn) Close() error { return nil }
+
+func (poolConn) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+
+func init() {
+	sql.Register("pooltest", poolDriver{})
+}
+
+func TestPoolExhaustion(t *testing.T) {
+	sqlDB, err := sql.Open("pooltest", "")
+	assert.NoError(t, err)
+	defer sqlDB.Close()
+	sqlDB.SetMaxOpenConns(1)
+	ctx := context.Background()
+	held, err := sqlDB.Conn(ctx)
+	assert.NoError(t, err)
+	// A second connection waits until the first one is released
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		conn, err := sqlDB.Conn(ctx)
+		assert.NoError(t, err)
+		conn.Close()
+	}()
+	time.Sleep(50 * time.Millisecond)
+	stats := NewPoolStats(PoolPrimary, sqlDB.Stats())
+	assert.Equal(t, 1, stats.MaxOpen)
+	assert.Equal(t, 1, stats.InUse)
+	held.Close()
+	wg.Wait()
+	stats = NewPoolStats(PoolPrimary, sqlDB.Stats())
+	assert.Equal(t, int64(1), stats.WaitCount)
+	assert.GreaterOrEqual(t, stats.WaitDuration, 50*time.Millisecond)
+	assert.Equal(t, 0, stats.InUse)
+	monitor := NewPoolMonitor(10 * time.Millisecond)
+	assert.Equal(t, []string{PoolPrimary}, monitor.Check([]PoolStats{stats}))
+	// No new waits since the previous check
+	assert.Empty(t, monitor.Check([]PoolStats{stats}))
+	// Waits shorter than the threshold are fine
+	monitor = NewPoolMonitor(time.Second)
+	assert.Empty(t, monitor.Check([]PoolStats{stats}))
+}
+
+func TestPoolMonitorReset(t *testing.T) {
+	monitor := NewPoolMonitor(0)
+	assert.Equal(t, DefaultPoolWaitThreshold, monitor.Threshold)
+	monitor.Check([]PoolStats{{Pool: "replica:5432", WaitCount: 10, WaitDuration: 10 * time.Second}})
+	// The pool was opened again and counters started from zero
+	exhausted := monitor.Check([]PoolStats{{Pool: "replica:5432", WaitCount: 2, WaitDuration: time.Second}})
+	assert.Equal(t, []string{"replica:5432"}, exhausted)
+}
+
+func TestPoolCapacity(t *testing.T) {
+	c := NewPoolCapacity(100, 3, 200, 3)
+	assert.Equal(t, 300, c.Potential)
+	assert.True(t, c.Exceeded())
+	assert.Equal(t, 65, c.Suggested())
+	c = NewPoolCapacity(50, 0, 200, 3)
+	assert.Equal(t, 1, c.Instances)
+	assert.False(t, c.Exceeded())
+	// Pools without limit can always exceed the server
+	assert.True(t, NewPoolCapacity(0, 1, 200, 3).Exceeded())
+}
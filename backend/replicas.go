@@ -0,0 +1,173 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// DefaultReplicaCheck is the default interval to check the health of read replicas
+	DefaultReplicaCheck = 10 * time.Second
+	// DefaultPrimaryWindow is the default time reads use the primary after a change made by another service
+	DefaultPrimaryWindow = 30 * time.Second
+	// Query to get the replication lag in seconds, an idle replica that replayed everything has no lag
+	replicaLagQuery = "SELECT CASE WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0 ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0) END"
+)
+
+// DBReplica to hold one read replica and its health
+type DBReplica struct {
+	Conn    *gorm.DB
+	Address string
+	DSN     string
+	healthy int32
+}
+
+// Healthy to check if the replica can receive reads
+func (r *DBReplica) Healthy() bool {
+	return atomic.LoadInt32(&r.healthy) == 1
+}
+
+// Helper to mark the replica as healthy or not
+func (r *DBReplica) setHealthy(healthy bool) {
+	var v int32
+	if healthy {
+		v = 1
+	}
+	atomic.StoreInt32(&r.healthy, v)
+}
+
+// Helper to open connections to all configured read replicas
+func (db *DBManager) prepareReplicas() {
+	for _, address := range db.Config.Replicas {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			host, port = address, db.Config.Port
+		}
+		cfg := *db.Config
+		cfg.Host = host
+		cfg.Port = port
+		replica := &DBReplica{Address: address, DSN: PrepareDSN(cfg)}
+		conn, err := db.openDB(replica.DSN)
+		if err != nil {
+			// Replica stays out of the rotation until it is reachable
+			log.Printf("Failed to get DB replica %s - %v", address, err)
+		} else {
+			replica.Conn = conn
+			replica.setHealthy(true)
+		}
+		db.Replicas = append(db.Replicas, replica)
+	}
+}
+
+// Reader to get the connection to be used for reads, it rotates across healthy
+// replicas and uses the primary if there are none
+func (db *DBManager) Reader() *gorm.DB {
+	total := len(db.Replicas)
+	if total == 0 || time.Now().UnixNano() < atomic.LoadInt64(&db.primary) {
+		return db.Conn
+	}
+	start := atomic.AddUint32(&db.next, 1)
+	for i := 0; i < total; i++ {
+		replica := db.Replicas[(int(start)+i)%total]
+		if replica.Healthy() {
+			return replica.Conn
+		}
+	}
+	return db.Conn
+}
+
+// ReadPrimary to use the primary for reads during some time, so a change just made by another service is
+// read before the replicas replay it
+func (db *DBManager) ReadPrimary(window time.Duration) {
+	until := time.Now().Add(window).UnixNano()
+	for {
+		current := atomic.LoadInt64(&db.primary)
+		if current >= until || atomic.CompareAndSwapInt64(&db.primary, current, until) {
+			return
+		}
+	}
+}
+
+// CheckReplica to verify if a replica is reachable and within the allowed lag
+func (db *DBManager) CheckReplica(ctx context.Context, replica *DBReplica) error {
+	if replica.Conn == nil {
+		conn, err := db.openDB(replica.DSN)
+		if err != nil {
+			return err
+		}
+		replica.Conn = conn
+	}
+	sqlDB, err := replica.Conn.DB()
+	if err != nil {
+		return err
+	}
+	if err := sqlDB.PingContext(ctx); err != nil {
+		return err
+	}
+	if db.Config.MaxReplicaLag <= 0 {
+		return nil
+	}
+	var lag float64
+	if err := replica.Conn.WithContext(ctx).Raw(replicaLagQuery).Scan(&lag).Error; err != nil {
+		return fmt.Errorf("Lag %v", err)
+	}
+	if lag > float64(db.Config.MaxReplicaLag) {
+		return fmt.Errorf("replication lag of %.0fs exceeds %ds", lag, db.Config.MaxReplicaLag)
+	}
+	return nil
+}
+
+// CheckReplicas to update the health of all replicas, returns the number of healthy ones
+func (db *DBManager) CheckReplicas(ctx context.Context) int {
+	healthy := 0
+	for _, replica := range db.Replicas {
+		err := db.CheckReplica(ctx, replica)
+		if err != nil && replica.Healthy() {
+			log.Printf("DB replica %s removed from reads - %v", replica.Address, err)
+		}
+		if err == nil && !replica.Healthy() {
+			log.Printf("DB replica %s back for reads", replica.Address)
+		}
+		replica.setHealthy(err == nil)
+		if err == nil {
+			healthy++
+		}
+	}
+	return healthy
+}
+
+// StartReplicaChecks to check the health of replicas periodically, until ctx is cancelled or the returned
+// function is called, which waits for the checks to stop
+func (db *DBManager) StartReplicaChecks(ctx context.Context, interval time.Duration) func() {
+	if len(db.Replicas) == 0 {
+		return func() {}
+	}
+	if interval <= 0 {
+		interval = DefaultReplicaCheck
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				db.CheckReplicas(ctx)
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
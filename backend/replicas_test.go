@@ -0,0 +1,89 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestReadPrimary(t *testing.T) {
+	replica := &DBReplica{Conn: &gorm.DB{}, Address: "replica:5432"}
+	replica.setHealthy(true)
+	db := &DBManager{Conn: &gorm.DB{}, Replicas: []*DBReplica{replica}}
+	assert.Same(t, replica.Conn, db.Reader())
+	db.ReadPrimary(time.Minute)
+	assert.Same(t, db.Conn, db.Reader())
+	// A shorter window does not end the current one
+	db.ReadPrimary(-time.Second)
+	assert.Same(t, db.Conn, db.Reader())
+	db.primary = time.Now().Add(-time.Second).UnixNano()
+	assert.Same(t, replica.Conn, db.Reader())
+}
+
+// Helper to get a replica with a mocked connection that expects pings
+func mockReplica(t *testing.T, address string) (*DBReplica, sqlmock.Sqlmock) {
+	mockDB, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Fatalf("failed to open mock sql db, got error: %v", err)
+	}
+	t.Cleanup(func() { mockDB.Close() })
+	conn, err := gorm.Open(postgres.New(postgres.Config{Conn: mockDB}), &gorm.Config{DisableAutomaticPing: true})
+	if err != nil {
+		t.Fatalf("unable to create new postgres database: %v", err)
+	}
+	return &DBReplica{Conn: conn, Address: address}, mock
+}
+
+func TestCheckReplicas(t *testing.T) {
+	lagging, laggingMock := mockReplica(t, "lagging:5432")
+	laggingMock.ExpectPing()
+	laggingMock.ExpectQuery(`SELECT CASE WHEN pg_last_wal_receive_lsn`).WillReturnRows(sqlmock.NewRows([]string{"lag"}).AddRow(30))
+	lagging.setHealthy(true)
+	down, downMock := mockReplica(t, "down:5432")
+	downMock.ExpectPing().WillReturnError(errors.New("connection refused"))
+	down.setHealthy(true)
+	ready, readyMock := mockReplica(t, "ready:5432")
+	readyMock.ExpectPing()
+	readyMock.ExpectQuery(`SELECT CASE WHEN pg_last_wal_receive_lsn`).WillReturnRows(sqlmock.NewRows([]string{"lag"}).AddRow(1))
+	db := &DBManager{Conn: &gorm.DB{}, Config: &JSONConfigurationDB{MaxReplicaLag: 10}, Replicas: []*DBReplica{lagging, down, ready}}
+	assert.Equal(t, 1, db.CheckReplicas(context.Background()))
+	assert.False(t, lagging.Healthy())
+	assert.False(t, down.Healthy())
+	assert.True(t, ready.Healthy())
+	// Reads only go to the healthy replica
+	for i := 0; i < 3; i++ {
+		assert.Same(t, ready.Conn, db.Reader())
+	}
+	for _, mock := range []sqlmock.Sqlmock{laggingMock, downMock, readyMock} {
+		assert.NoError(t, mock.ExpectationsWereMet())
+	}
+}
+
+func TestStartReplicaChecksStop(t *testing.T) {
+	replica, mock := mockReplica(t, "replica:5432")
+	// Only one check is expected, later pings fail and would remove the replica from reads
+	mock.ExpectPing()
+	db := &DBManager{Conn: &gorm.DB{}, Config: &JSONConfigurationDB{}, Replicas: []*DBReplica{replica}}
+	stop := db.StartReplicaChecks(context.Background(), 5*time.Millisecond)
+	deadline := time.Now().Add(time.Second)
+	for !replica.Healthy() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	stop()
+	assert.True(t, replica.Healthy())
+	time.Sleep(20 * time.Millisecond)
+	assert.True(t, replica.Healthy())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestStartReplicaChecksWithoutReplicas(t *testing.T) {
+	db := &DBManager{Conn: &gorm.DB{}}
+	stop := db.StartReplicaChecks(context.Background(), time.Millisecond)
+	stop()
+}
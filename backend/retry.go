@@ -0,0 +1,115 @@
+package backend
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// RetryConfig to hold how operations are retried after transient failures
+type RetryConfig struct {
+	// Total number of attempts, including the first one
+	Attempts int
+	// Base delay before the first retry, doubled on every retry
+	Base time.Duration
+	// Maximum delay between retries
+	Max time.Duration
+}
+
+// DefaultRetry is the retry configuration used by the managers, short enough to stay within a node request
+var DefaultRetry = RetryConfig{
+	Attempts: 3,
+	Base:     50 * time.Millisecond,
+	Max:      time.Second,
+}
+
+// RetryStats to count the retries of one operation and their outcomes
+type RetryStats struct {
+	// Retries performed after transient failures
+	Retries int64 `json:"retries"`
+	// Operations that succeeded after at least one retry
+	Recovered int64 `json:"recovered"`
+	// Operations that were still failing with transient errors when attempts ran out
+	Exhausted int64 `json:"exhausted"`
+	// Operations that failed with an error that is not retried
+	Failed int64 `json:"failed"`
+}
+
+var (
+	retryMutex sync.Mutex
+	retryStats = make(map[string]RetryStats)
+)
+
+// GetRetryStats to get a copy of the retry counters by operation
+func GetRetryStats() map[string]RetryStats {
+	retryMutex.Lock()
+	defer retryMutex.Unlock()
+	stats := make(map[string]RetryStats, len(retryStats))
+	for op, s := range retryStats {
+		stats[op] = s
+	}
+	return stats
+}
+
+// Helper to update the counters of one operation
+func recordRetry(op string, update func(*RetryStats)) {
+	retryMutex.Lock()
+	defer retryMutex.Unlock()
+	s := retryStats[op]
+	update(&s)
+	retryStats[op] = s
+}
+
+// Retry to run an idempotent operation with the default configuration, retrying transient failures.
+// Never use it for writes that are not safe to apply twice, like inserts or counter increments.
+func Retry(ctx context.Context, op string, fn func() error) error {
+	return RetryWith(ctx, DefaultRetry, op, fn)
+}
+
+// RetryWith to run an idempotent operation, retrying transient failures with jittered exponential backoff.
+// When attempts run out the error is returned as a transient DBError, other errors are returned as they are.
+func RetryWith(ctx context.Context, cfg RetryConfig, op string, fn func() error) error {
+	attempts := cfg.Attempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			recordRetry(op, func(s *RetryStats) { s.Retries++ })
+			select {
+			case <-ctx.Done():
+				return &DBError{Kind: KindTransient, Op: op, Err: err}
+			case <-time.After(backoff(cfg, i)):
+			}
+		}
+		err = fn()
+		if err == nil {
+			if i > 0 {
+				recordRetry(op, func(s *RetryStats) { s.Recovered++ })
+			}
+			return nil
+		}
+		if !IsTransient(err) {
+			if !IsNotFound(err) {
+				recordRetry(op, func(s *RetryStats) { s.Failed++ })
+			}
+			return err
+		}
+	}
+	recordRetry(op, func(s *RetryStats) { s.Exhausted++ })
+	return &DBError{Kind: KindTransient, Op: op, Err: err}
+}
+
+// Helper to calculate the delay before a retry, with full jitter to avoid all nodes retrying together
+func backoff(cfg RetryConfig, retry int) time.Duration {
+	delay := cfg.Base << uint(retry-1)
+	if delay <= 0 || (cfg.Max > 0 && delay > cfg.Max) {
+		delay = cfg.Max
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(delay)) + 1)
+}
@@ -0,0 +1,188 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// DefaultTrashDays is the default days that deleted items can be restored before they are purged
+	DefaultTrashDays int = 7
+	// DefaultTrashInterval is the default interval to purge items deleted before the window
+	DefaultTrashInterval = time.Hour
+)
+
+// Kinds of items that are kept in the trash when deleted
+const (
+	TrashQueries   string = "queries"
+	TrashCarves    string = "carves"
+	TrashSaved     string = "saved"
+	TrashTemplates string = "templates"
+	TrashTags      string = "tags"
+)
+
+// TrashKinds to get all the kinds of items kept in the trash
+var TrashKinds = []string{TrashQueries, TrashCarves, TrashSaved, TrashTemplates, TrashTags}
+
+// HardDeletion to audit deletes that skip the trash, so they can not be restored
+type HardDeletion struct {
+	gorm.Model
+	Kind     string `gorm:"index"`
+	Name     string
+	Username string
+}
+
+// TrashItem to show an item in the trash, with when and who deleted it
+type TrashItem struct {
+	Kind      string    `json:"kind"`
+	Name      string    `json:"name"`
+	Detail    string    `json:"detail"`
+	DeletedBy string    `json:"deleted_by"`
+	DeletedAt time.Time `json:"deleted_at"`
+	PurgeAt   time.Time `json:"purge_at"`
+}
+
+// NewTrashItem to show an item deleted at a time, with when it is purged for the window of days
+func NewTrashItem(kind, name, detail, deletedBy string, deletedAt gorm.DeletedAt, days int) TrashItem {
+	item := TrashItem{Kind: kind, Name: name, Detail: detail, DeletedBy: deletedBy}
+	if deletedAt.Valid {
+		item.DeletedAt = deletedAt.Time
+		item.PurgeAt = deletedAt.Time.Add(TrashWindow(days))
+	}
+	return item
+}
+
+// MigrateTrash to create the table to audit deletes that skip the trash
+func MigrateTrash(db *gorm.DB) error {
+	// table hard_deletions
+	if err := db.AutoMigrate(&HardDeletion{}); err != nil {
+		return fmt.Errorf("Failed to AutoMigrate table (hard_deletions): %v", err)
+	}
+	return nil
+}
+
+// ValidTrashKind to check if a kind of item is kept in the trash
+func ValidTrashKind(kind string) bool {
+	for _, k := range TrashKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// TrashWindow to get how long deleted items can be restored, days not set use the default
+func TrashWindow(days int) time.Duration {
+	if days <= 0 {
+		days = DefaultTrashDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// TrashCutoff to get the time before which deleted items are purged
+func TrashCutoff(days int, now time.Time) time.Time {
+	return now.Add(-TrashWindow(days))
+}
+
+// SoftDelete to move an item to the trash, recording the user that deleted it.
+// The model must have a deleted_by column besides the deleted_at of gorm.
+func SoftDelete(db *gorm.DB, model interface{}, user string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(model).Update("deleted_by", user).Error; err != nil {
+			return fmt.Errorf("Update %v", err)
+		}
+		if err := tx.Delete(model).Error; err != nil {
+			return fmt.Errorf("Delete %v", err)
+		}
+		return nil
+	})
+}
+
+// Restore to take an item out of the trash
+func Restore(db *gorm.DB, model interface{}) error {
+	if err := db.Unscoped().Model(model).Updates(map[string]interface{}{"deleted_at": nil, "deleted_by": ""}).Error; err != nil {
+		return fmt.Errorf("Restore %v", err)
+	}
+	return nil
+}
+
+// HardDelete to delete an item skipping the trash, the delete is audited with the user that requested it
+func HardDelete(db *gorm.DB, model interface{}, kind, name, user string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Delete(model).Error; err != nil {
+			return fmt.Errorf("Delete %v", err)
+		}
+		audit := HardDeletion{Kind: kind, Name: name, Username: user}
+		if err := tx.Create(&audit).Error; err != nil {
+			return fmt.Errorf("Create %v", err)
+		}
+		return nil
+	})
+}
+
+// InTrash to scope a query to the items deleted after the cutoff, newest first
+func InTrash(cutoff time.Time) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Unscoped().Where("deleted_at IS NOT NULL AND deleted_at > ?", cutoff).Order("deleted_at DESC")
+	}
+}
+
+// Expired to scope a query to the items deleted before the cutoff, to be purged
+func Expired(cutoff time.Time) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Unscoped().Where("deleted_at IS NOT NULL AND deleted_at <= ?", cutoff)
+	}
+}
+
+// TrashPurger to delete permanently the items of a manager deleted before the cutoff, returns how many
+type TrashPurger func(cutoff time.Time) (int64, error)
+
+// RunTrashPurge to purge the items deleted before the window, only by the leader if a lock is provided
+func RunTrashPurge(leader *LeaderLock, days int, now time.Time, purgers ...TrashPurger) int64 {
+	if leader != nil {
+		isLeader, err := leader.Acquire()
+		if err != nil {
+			log.Printf("error acquiring leader lock %v", err)
+			return 0
+		}
+		if !isLeader {
+			return 0
+		}
+	}
+	cutoff := TrashCutoff(days, now)
+	var purged int64
+	for _, purge := range purgers {
+		n, err := purge(cutoff)
+		if err != nil {
+			log.Printf("error purging trash %v", err)
+			continue
+		}
+		purged += n
+	}
+	return purged
+}
+
+// StartTrashPurge to purge periodically the items deleted before the window of days
+func StartTrashPurge(ctx context.Context, leader *LeaderLock, interval time.Duration, days func() int, purgers ...TrashPurger) {
+	if interval <= 0 {
+		interval = DefaultTrashInterval
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if purged := RunTrashPurge(leader, days(), time.Now(), purgers...); purged > 0 {
+				log.Printf("Purged %d items from the trash", purged)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
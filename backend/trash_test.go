@@ -0,0 +1,54 @@
+package backend
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestTrashWindow(t *testing.T) {
+	assert.Equal(t, 7*24*time.Hour, TrashWindow(0))
+	assert.Equal(t, 7*24*time.Hour, TrashWindow(-3))
+	assert.Equal(t, 30*24*time.Hour, TrashWindow(30))
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2024, 5, 9, 12, 0, 0, 0, time.UTC), TrashCutoff(1, now))
+}
+
+func TestNewTrashItem(t *testing.T) {
+	deleted := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	item := NewTrashItem(TrashQueries, "query_1", "SELECT 1;", "admin", gorm.DeletedAt{Time: deleted, Valid: true}, 2)
+	assert.Equal(t, "admin", item.DeletedBy)
+	assert.Equal(t, deleted, item.DeletedAt)
+	assert.Equal(t, deleted.Add(48*time.Hour), item.PurgeAt)
+	// Items not deleted have no times
+	item = NewTrashItem(TrashTags, "tag", "", "", gorm.DeletedAt{}, 2)
+	assert.True(t, item.PurgeAt.IsZero())
+}
+
+func TestValidTrashKind(t *testing.T) {
+	for _, k := range TrashKinds {
+		assert.True(t, ValidTrashKind(k))
+	}
+	assert.False(t, ValidTrashKind("nodes"))
+}
+
+func TestRunTrashPurge(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	var cutoffs []time.Time
+	purger := func(n int64, err error) TrashPurger {
+		return func(cutoff time.Time) (int64, error) {
+			cutoffs = append(cutoffs, cutoff)
+			return n, err
+		}
+	}
+	// Errors of a purger are logged and do not stop the rest
+	purged := RunTrashPurge(nil, 3, now, purger(2, nil), purger(5, errors.New("failed")), purger(1, nil))
+	assert.Equal(t, int64(3), purged)
+	assert.Len(t, cutoffs, 3)
+	for _, c := range cutoffs {
+		assert.Equal(t, now.Add(-72*time.Hour), c)
+	}
+}
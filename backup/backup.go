@@ -0,0 +1,348 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	// FormatVersion of the content of archives, restores only accept the same version
+	FormatVersion = 1
+	// DefaultBatchSize for the rows read and inserted at once
+	DefaultBatchSize = 500
+	// Name of the manifest in the archive, it is the last file
+	manifestName = "manifest.json"
+	// Extension of the files with the rows of each table
+	tableExt = ".gob"
+)
+
+// Table to back up all the rows of one model
+type Table struct {
+	// Model is a zero value of the model of the table, like nodes.OsqueryNode{}
+	Model interface{}
+	// Optional tables are only included when requested, like raw result logs
+	Optional bool
+}
+
+// TableManifest to describe the rows of one table in the archive
+type TableManifest struct {
+	Name   string `json:"name"`
+	Rows   int64  `json:"rows"`
+	SHA256 string `json:"sha256"`
+}
+
+// Manifest to describe the content of an archive, used to check its integrity when it is restored
+type Manifest struct {
+	Version   int             `json:"version"`
+	CreatedAt time.Time       `json:"created_at"`
+	Service   string          `json:"service"`
+	Optional  bool            `json:"optional"`
+	Tables    []TableManifest `json:"tables"`
+}
+
+// Options to create archives
+type Options struct {
+	// Service version that creates the archive
+	Service string
+	// Optional to include the optional tables
+	Optional bool
+	// BatchSize for the rows read and inserted at once
+	BatchSize int
+}
+
+// Helper to get the name of the table of a model
+func tableName(db *gorm.DB, model interface{}) (string, error) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return "", fmt.Errorf("Parse %v", err)
+	}
+	return stmt.Schema.Table, nil
+}
+
+// Helper to create a pointer to an empty slice of the model
+func newRows(model interface{}) reflect.Value {
+	return reflect.New(reflect.SliceOf(reflect.TypeOf(model)))
+}
+
+// Helper to write the rows of one table to a temporary file, in batches so tables do not need to fit in memory
+func dumpTable(tx *gorm.DB, model interface{}, batch int) (*os.File, TableManifest, error) {
+	var tm TableManifest
+	tmp, err := os.CreateTemp("", "osctrl-backup-*"+tableExt)
+	if err != nil {
+		return nil, tm, err
+	}
+	h := sha256.New()
+	enc := gob.NewEncoder(io.MultiWriter(tmp, h))
+	rows := newRows(model)
+	res := tx.Unscoped().Model(model).FindInBatches(rows.Interface(), batch, func(b *gorm.DB, n int) error {
+		tm.Rows += int64(b.RowsAffected)
+		return enc.Encode(rows.Interface())
+	})
+	if res.Error != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, tm, fmt.Errorf("FindInBatches %v", res.Error)
+	}
+	tm.SHA256 = fmt.Sprintf("%x", h.Sum(nil))
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, tm, err
+	}
+	return tmp, tm, nil
+}
+
+// Helper to add one file to the archive
+func addFile(tw *tar.Writer, name string, size int64, content io.Reader) error {
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    size,
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := io.Copy(tw, content)
+	return err
+}
+
+// Create to write an encrypted archive with all the rows of the tables. All tables are read in the same
+// repeatable read transaction, so the archive is a consistent snapshot of the database.
+func Create(db *gorm.DB, tables []Table, out io.Writer, passphrase string, opts Options) (Manifest, error) {
+	manifest := Manifest{
+		Version:   FormatVersion,
+		CreatedAt: time.Now(),
+		Service:   opts.Service,
+		Optional:  opts.Optional,
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+	enc, err := NewEncryptWriter(out, passphrase)
+	if err != nil {
+		return manifest, err
+	}
+	gz := gzip.NewWriter(enc)
+	tw := tar.NewWriter(gz)
+	tx := db.Begin(&sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if tx.Error != nil {
+		return manifest, fmt.Errorf("Begin %v", tx.Error)
+	}
+	defer tx.Rollback()
+	for _, t := range tables {
+		if t.Optional && !opts.Optional {
+			continue
+		}
+		name, err := tableName(db, t.Model)
+		if err != nil {
+			return manifest, err
+		}
+		tmp, tm, err := dumpTable(tx, t.Model, opts.BatchSize)
+		if err != nil {
+			return manifest, fmt.Errorf("table %s: %v", name, err)
+		}
+		info, err := tmp.Stat()
+		if err == nil {
+			err = addFile(tw, name+tableExt, info.Size(), tmp)
+		}
+		tmp.Close()
+		os.Remove(tmp.Name())
+		if err != nil {
+			return manifest, fmt.Errorf("table %s: %v", name, err)
+		}
+		tm.Name = name
+		manifest.Tables = append(manifest.Tables, tm)
+	}
+	raw, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return manifest, err
+	}
+	if err := addFile(tw, manifestName, int64(len(raw)), strings.NewReader(string(raw))); err != nil {
+		return manifest, err
+	}
+	if err := tw.Close(); err != nil {
+		return manifest, err
+	}
+	if err := gz.Close(); err != nil {
+		return manifest, err
+	}
+	if err := enc.Close(); err != nil {
+		return manifest, err
+	}
+	return manifest, nil
+}
+
+// Helper to check that the tables of the restore do not have rows
+func checkEmpty(db *gorm.DB, tables map[string]Table) error {
+	for name, t := range tables {
+		var count int64
+		if err := db.Unscoped().Model(t.Model).Count(&count).Error; err != nil {
+			return fmt.Errorf("Count %s %v", name, err)
+		}
+		if count > 0 {
+			return fmt.Errorf("database is not empty, table %s has %d rows", name, count)
+		}
+	}
+	return nil
+}
+
+// Helper to insert the rows of one table from the archive
+func loadTable(tx *gorm.DB, t Table, content io.Reader, batch int) (TableManifest, error) {
+	var tm TableManifest
+	h := sha256.New()
+	dec := gob.NewDecoder(io.TeeReader(content, h))
+	for {
+		rows := newRows(t.Model)
+		if err := dec.Decode(rows.Interface()); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return tm, fmt.Errorf("Decode %v", err)
+		}
+		n := rows.Elem().Len()
+		if n == 0 {
+			continue
+		}
+		if err := tx.Session(&gorm.Session{SkipHooks: true}).CreateInBatches(rows.Interface(), batch).Error; err != nil {
+			return tm, fmt.Errorf("CreateInBatches %v", err)
+		}
+		tm.Rows += int64(n)
+	}
+	tm.SHA256 = fmt.Sprintf("%x", h.Sum(nil))
+	return tm, nil
+}
+
+// Helper to move the sequences of primary keys after the restored rows, so new rows do not collide
+func resetSequence(tx *gorm.DB, model interface{}) error {
+	if tx.Dialector.Name() != "postgres" {
+		return nil
+	}
+	stmt := &gorm.Statement{DB: tx}
+	if err := stmt.Parse(model); err != nil {
+		return err
+	}
+	pk := stmt.Schema.PrioritizedPrimaryField
+	if pk == nil || !pk.AutoIncrement || pk.DataType != schema.Uint && pk.DataType != schema.Int {
+		return nil
+	}
+	query := fmt.Sprintf("SELECT setval(pg_get_serial_sequence('%s', '%s'), COALESCE((SELECT MAX(%s) FROM %s), 0) + 1, false)", stmt.Schema.Table, pk.DBName, pk.DBName, stmt.Quote(stmt.Schema.Table))
+	return tx.Exec(query).Error
+}
+
+// Helper to compare the restored tables with the manifest of the archive
+func verify(manifest Manifest, restored map[string]TableManifest) error {
+	if manifest.Version != FormatVersion {
+		return fmt.Errorf("unsupported backup version %d", manifest.Version)
+	}
+	if len(manifest.Tables) != len(restored) {
+		return fmt.Errorf("archive has %d tables and the manifest %d", len(restored), len(manifest.Tables))
+	}
+	for _, tm := range manifest.Tables {
+		r, ok := restored[tm.Name]
+		if !ok {
+			return fmt.Errorf("table %s is missing from the archive", tm.Name)
+		}
+		if r.Rows != tm.Rows || r.SHA256 != tm.SHA256 {
+			return fmt.Errorf("table %s does not match the manifest, %d rows restored of %d", tm.Name, r.Rows, tm.Rows)
+		}
+	}
+	return nil
+}
+
+// Restore to load an encrypted archive into an empty database. Everything is restored in one transaction, that
+// is only committed when every table matches the manifest, so a failed restore leaves the database empty.
+func Restore(db *gorm.DB, tables []Table, in io.Reader, passphrase string, batch int) (Manifest, error) {
+	var manifest Manifest
+	if batch <= 0 {
+		batch = DefaultBatchSize
+	}
+	known := make(map[string]Table)
+	for _, t := range tables {
+		if err := db.AutoMigrate(t.Model); err != nil {
+			return manifest, fmt.Errorf("AutoMigrate %v", err)
+		}
+		name, err := tableName(db, t.Model)
+		if err != nil {
+			return manifest, err
+		}
+		known[name] = t
+	}
+	if err := checkEmpty(db, known); err != nil {
+		return manifest, err
+	}
+	dec, err := NewDecryptReader(in, passphrase)
+	if err != nil {
+		return manifest, err
+	}
+	gz, err := gzip.NewReader(dec)
+	if err != nil {
+		return manifest, fmt.Errorf("gzip %v", err)
+	}
+	tr := tar.NewReader(gz)
+	restored := make(map[string]TableManifest)
+	foundManifest := false
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if foundManifest {
+				return fmt.Errorf("unexpected %s after the manifest", hdr.Name)
+			}
+			if hdr.Name == manifestName {
+				if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+					return fmt.Errorf("manifest %v", err)
+				}
+				foundManifest = true
+				continue
+			}
+			name := strings.TrimSuffix(hdr.Name, tableExt)
+			t, ok := known[name]
+			if !ok {
+				return fmt.Errorf("unknown table %s in the archive", name)
+			}
+			if _, dup := restored[name]; dup {
+				return fmt.Errorf("duplicated table %s in the archive", name)
+			}
+			tm, err := loadTable(tx, t, tr, batch)
+			if err != nil {
+				return fmt.Errorf("table %s: %v", name, err)
+			}
+			tm.Name = name
+			restored[name] = tm
+			if err := resetSequence(tx, t.Model); err != nil {
+				return fmt.Errorf("table %s sequence: %v", name, err)
+			}
+		}
+		if !foundManifest {
+			return fmt.Errorf("manifest is missing from the archive")
+		}
+		// Read until the end, so the checksum of the compression and the last encrypted chunk are verified
+		if _, err := io.Copy(io.Discard, gz); err != nil {
+			return fmt.Errorf("compressed archive %v", err)
+		}
+		if _, err := io.Copy(io.Discard, dec); err != nil {
+			return err
+		}
+		return verify(manifest, restored)
+	})
+	return manifest, err
+}
@@ -0,0 +1,86 @@
+package backup
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper to encrypt content with a passphrase
+func encrypt(t *testing.T, content []byte, passphrase string) []byte {
+	var out bytes.Buffer
+	enc, err := NewEncryptWriter(&out, passphrase)
+	assert.NoError(t, err)
+	_, err = enc.Write(content)
+	assert.NoError(t, err)
+	assert.NoError(t, enc.Close())
+	return out.Bytes()
+}
+
+// Helper to decrypt an archive with a passphrase
+func decrypt(archive []byte, passphrase string) ([]byte, error) {
+	dec, err := NewDecryptReader(bytes.NewReader(archive), passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(dec)
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	for _, size := range []int{0, 10, chunkSize, 3*chunkSize + 7} {
+		content := bytes.Repeat([]byte("osctrl"), size/6+1)[:size]
+		archive := encrypt(t, content, "correct horse")
+		assert.False(t, bytes.Contains(archive, []byte("osctrlosctrl")))
+		plain, err := decrypt(archive, "correct horse")
+		assert.NoError(t, err)
+		assert.Equal(t, content, plain)
+	}
+}
+
+func TestDecryptErrors(t *testing.T) {
+	content := bytes.Repeat([]byte("node_key"), chunkSize/4)
+	archive := encrypt(t, content, "correct horse")
+	// Wrong passphrase
+	_, err := decrypt(archive, "wrong horse")
+	assert.Equal(t, ErrPassphrase, err)
+	// Empty passphrase
+	_, err = NewEncryptWriter(&bytes.Buffer{}, "")
+	assert.Error(t, err)
+	// Modified content
+	modified := append([]byte{}, archive...)
+	modified[len(modified)-20] ^= 0xff
+	_, err = decrypt(modified, "correct horse")
+	assert.Equal(t, ErrPassphrase, err)
+	// Truncated after a full chunk, the last chunk is missing
+	_, err = decrypt(archive[:len(archiveMagic)+saltSize+4+chunkSize+16], "correct horse")
+	assert.Error(t, err)
+	// Data after the last chunk
+	_, err = decrypt(append(append([]byte{}, archive...), 0), "correct horse")
+	assert.Error(t, err)
+	// Not an archive
+	_, err = decrypt([]byte("plain tar.gz content"), "correct horse")
+	assert.Error(t, err)
+}
+
+func TestVerify(t *testing.T) {
+	manifest := Manifest{
+		Version: FormatVersion,
+		Tables: []TableManifest{
+			{Name: "osquery_nodes", Rows: 2, SHA256: "abc"},
+			{Name: "tls_environments", Rows: 1, SHA256: "def"},
+		},
+	}
+	restored := map[string]TableManifest{
+		"osquery_nodes":    {Name: "osquery_nodes", Rows: 2, SHA256: "abc"},
+		"tls_environments": {Name: "tls_environments", Rows: 1, SHA256: "def"},
+	}
+	assert.NoError(t, verify(manifest, restored))
+	restored["osquery_nodes"] = TableManifest{Name: "osquery_nodes", Rows: 1, SHA256: "abc"}
+	assert.Error(t, verify(manifest, restored))
+	delete(restored, "osquery_nodes")
+	assert.Error(t, verify(manifest, restored))
+	manifest.Version = FormatVersion + 1
+	assert.Error(t, verify(manifest, map[string]TableManifest{}))
+}
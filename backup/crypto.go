@@ -0,0 +1,201 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// Magic bytes at the beginning of encrypted archives, with the version of the encryption format
+	archiveMagic = "OSCBAK01"
+	// Size of the salt to derive the key from the passphrase
+	saltSize = 16
+	// Size of the plaintext of each encrypted chunk
+	chunkSize = 64 * 1024
+	// Parameters of scrypt to derive the key
+	scryptN = 32768
+	scryptR = 8
+	scryptP = 1
+	keySize = 32
+)
+
+// ErrPassphrase is returned when the archive can not be decrypted, with a wrong passphrase or when it was modified
+var ErrPassphrase = errors.New("wrong passphrase or corrupted archive")
+
+// Helper to derive the key of an archive from the passphrase
+func deriveKey(passphrase string, salt []byte) (cipher.AEAD, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("passphrase can not be empty")
+	}
+	key, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, keySize)
+	if err != nil {
+		return nil, fmt.Errorf("scrypt %v", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Helper to generate the nonce of one chunk, keys are unique per archive so a counter never repeats
+func chunkNonce(aead cipher.AEAD, counter uint64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce, counter)
+	return nonce
+}
+
+// Helper to generate the additional data of one chunk, so the last chunk can not be removed
+func chunkData(last bool) []byte {
+	if last {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// encryptWriter to encrypt an archive in authenticated chunks while it is written
+type encryptWriter struct {
+	out     io.Writer
+	aead    cipher.AEAD
+	buf     []byte
+	counter uint64
+	closed  bool
+}
+
+// NewEncryptWriter to encrypt everything written with a key derived from the passphrase, Close must be called to
+// write the last chunk, otherwise the archive is detected as truncated
+func NewEncryptWriter(out io.Writer, passphrase string) (io.WriteCloser, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := deriveKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := out.Write(append([]byte(archiveMagic), salt...)); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{out: out, aead: aead, buf: make([]byte, 0, chunkSize)}, nil
+}
+
+// Helper to encrypt and write one chunk, prefixed with its length
+func (e *encryptWriter) seal(last bool) error {
+	sealed := e.aead.Seal(nil, chunkNonce(e.aead, e.counter), e.buf, chunkData(last))
+	e.counter++
+	e.buf = e.buf[:0]
+	size := make([]byte, 4)
+	binary.BigEndian.PutUint32(size, uint32(len(sealed)))
+	if _, err := e.out.Write(size); err != nil {
+		return err
+	}
+	_, err := e.out.Write(sealed)
+	return err
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, fmt.Errorf("write to closed archive")
+	}
+	written := 0
+	for len(p) > 0 {
+		n := copy(e.buf[len(e.buf):cap(e.buf)], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+		if len(e.buf) == cap(e.buf) {
+			if err := e.seal(false); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (e *encryptWriter) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	return e.seal(true)
+}
+
+// decryptReader to read an archive encrypted by encryptWriter, verifying every chunk
+type decryptReader struct {
+	in      io.Reader
+	aead    cipher.AEAD
+	buf     []byte
+	counter uint64
+	last    bool
+}
+
+// NewDecryptReader to decrypt an archive with the passphrase used to create it
+func NewDecryptReader(in io.Reader, passphrase string) (io.Reader, error) {
+	header := make([]byte, len(archiveMagic)+saltSize)
+	if _, err := io.ReadFull(in, header); err != nil {
+		return nil, fmt.Errorf("not an osctrl backup: %v", err)
+	}
+	if !bytes.Equal(header[:len(archiveMagic)], []byte(archiveMagic)) {
+		return nil, fmt.Errorf("not an osctrl backup")
+	}
+	aead, err := deriveKey(passphrase, header[len(archiveMagic):])
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{in: in, aead: aead}, nil
+}
+
+// Helper to read and decrypt the next chunk
+func (d *decryptReader) open() error {
+	size := make([]byte, 4)
+	if _, err := io.ReadFull(d.in, size); err != nil {
+		return fmt.Errorf("truncated archive: %v", err)
+	}
+	n := binary.BigEndian.Uint32(size)
+	if n > chunkSize+uint32(d.aead.Overhead()) {
+		return ErrPassphrase
+	}
+	sealed := make([]byte, n)
+	if _, err := io.ReadFull(d.in, sealed); err != nil {
+		return fmt.Errorf("truncated archive: %v", err)
+	}
+	nonce := chunkNonce(d.aead, d.counter)
+	d.counter++
+	if plain, err := d.aead.Open(nil, nonce, sealed, chunkData(false)); err == nil {
+		d.buf = plain
+		return nil
+	}
+	plain, err := d.aead.Open(nil, nonce, sealed, chunkData(true))
+	if err != nil {
+		return ErrPassphrase
+	}
+	d.buf = plain
+	d.last = true
+	// Nothing can follow the last chunk
+	if n, _ := d.in.Read(make([]byte, 1)); n > 0 {
+		return fmt.Errorf("unexpected data after the end of the archive")
+	}
+	return nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.last {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
@@ -0,0 +1,9 @@
+module backup
+
+go 1.17
+
+require (
+	github.com/stretchr/testify v1.8.1
+	golang.org/x/crypto v0.4.0
+	gorm.io/gorm v1.24.3
+)
@@ -4,7 +4,6 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"time"
 
 	redis "github.com/go-redis/redis/v8"
 	"github.com/jmpsec/osctrl/types"
@@ -21,11 +20,11 @@ const (
 	// QueryExpiration in hours to expire entries for query logs
 	QueryExpiration = 24
 	// HashKeyResult to be used as hash-key to keep result logs
-	HashKeyResult = types.ResultLog
+	HashKeyResult = KeyNamespace + ":" + types.ResultLog
 	// HashKeyStatus to be used as hash-key to keep status logs
-	HashKeyStatus = types.StatusLog
+	HashKeyStatus = KeyNamespace + ":" + types.StatusLog
 	// HashKeyQuery to be used as hash-key to keep query logs
-	HashKeyQuery = types.QueryLog
+	HashKeyQuery = KeyNamespace + ":" + types.QueryLog
 )
 
 // RedisManager have access to cached data
@@ -44,6 +43,13 @@ type JSONConfigurationRedis struct {
 	StatusExpirationHours int    `json:"status_exp_hours"`
 	ResultExpirationHours int    `json:"result_exp_hours"`
 	QueryExpirationHours  int    `json:"query_exp_hours"`
+	// Expirations for keys where the service provides its own, used when it does not
+	IdempotencyExpirationHours int `json:"idempotency_exp_hours"`
+	LoginExpirationHours       int `json:"login_exp_hours"`
+	StatsExpirationHours       int `json:"stats_exp_hours"`
+	ResponseExpirationSeconds  int `json:"response_exp_seconds"`
+	EventsExpirationHours      int `json:"events_exp_hours"`
+	EnvsExpirationSeconds      int `json:"envs_exp_seconds"`
 }
 
 // CachedQueryWriteData to store in cache query logs
@@ -237,21 +243,22 @@ func (r *RedisManager) QueryLogs(name string) ([]CachedQueryWriteData, error) {
 
 // SetLogs to write logs to cache
 func (r *RedisManager) SetLogs(logType, hostID, envOrName string, data []byte) error {
-	var hKey string
-	var tExpire time.Duration
+	var hKey, family string
 	switch logType {
 	case types.StatusLog:
 		hKey = GenStatusKey(hostID, envOrName)
-		tExpire = time.Hour * time.Duration(r.Config.StatusExpirationHours)
+		family = FamilyStatus
 	case types.ResultLog:
 		hKey = GenResultKey(hostID, envOrName)
-		tExpire = time.Hour * time.Duration(r.Config.ResultExpirationHours)
+		family = FamilyResult
 	case types.QueryLog:
 		hKey = GenQueryKey(hostID, envOrName)
-		tExpire = time.Hour * time.Duration(r.Config.QueryExpirationHours)
+		family = FamilyQuery
+	default:
+		return fmt.Errorf("unknown log type %s", logType)
 	}
 	ctx := context.Background()
-	if err := r.Client.Set(ctx, hKey, data, tExpire).Err(); err != nil {
+	if err := r.Client.Set(ctx, hKey, data, r.expiration(family, 0)).Err(); err != nil {
 		return fmt.Errorf("%s Set: %s", logType, err)
 	}
 	return nil
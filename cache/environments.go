@@ -0,0 +1,88 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	redis "github.com/go-redis/redis/v8"
+	"github.com/jmpsec/osctrl/environments"
+)
+
+const (
+	// EnvsPrefix to be used as prefix for the keys of the environments map shared by osctrl-tls instances
+	EnvsPrefix = KeyNamespace + ":envs:"
+	// EnvsMapKey to keep the serialized environments map
+	EnvsMapKey = EnvsPrefix + "map"
+	// EnvsRefreshKey to elect the instance that refreshes the environments map from the DB
+	EnvsRefreshKey = EnvsPrefix + "refresh"
+	// EnvsSeconds by default to keep the environments map, so instances read the same map shortly after a change
+	EnvsSeconds = 10
+	// EnvsRefreshSeconds to hold the refresh of the environments map, other instances wait for it in the meantime
+	EnvsRefreshSeconds = 5
+)
+
+// Helper to serialize the environments map, the secrets of the environments are never kept in redis
+func encodeEnvs(envsmap environments.MapEnvironments) ([]byte, error) {
+	data, err := json.Marshal(envsmap.WithoutSecrets())
+	if err != nil {
+		return nil, fmt.Errorf("error serializing environments - %v", err)
+	}
+	return data, nil
+}
+
+// Helper to parse a serialized environments map
+func decodeEnvs(raw []byte) (environments.MapEnvironments, error) {
+	var envsmap environments.MapEnvironments
+	if err := json.Unmarshal(raw, &envsmap); err != nil {
+		return nil, fmt.Errorf("error parsing environments - %v", err)
+	}
+	return envsmap, nil
+}
+
+// GetEnvsCached to get the environments map shared by all instances, false if it is not cached. The environments
+// come without secrets, they have to be loaded from the DB
+func (r *RedisManager) GetEnvsCached(ctx context.Context) (environments.MapEnvironments, bool, error) {
+	raw, err := r.Client.Get(ctx, EnvsMapKey).Bytes()
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("environments Get: %s", err)
+	}
+	envsmap, err := decodeEnvs(raw)
+	if err != nil {
+		return nil, false, err
+	}
+	return envsmap, true, nil
+}
+
+// SetEnvsCached to share the environments map with all instances, it expires after the given time
+func (r *RedisManager) SetEnvsCached(ctx context.Context, envsmap environments.MapEnvironments, expire time.Duration) error {
+	data, err := encodeEnvs(envsmap)
+	if err != nil {
+		return err
+	}
+	if err := r.Client.Set(ctx, EnvsMapKey, data, r.expiration(FamilyEnvs, expire)).Err(); err != nil {
+		return fmt.Errorf("environments Set: %s", err)
+	}
+	return nil
+}
+
+// ClaimEnvsRefresh to elect the instance that refreshes the environments map, false if another one is refreshing it
+func (r *RedisManager) ClaimEnvsRefresh(ctx context.Context, instance string) (bool, error) {
+	claimed, err := r.Client.SetNX(ctx, EnvsRefreshKey, instance, r.expiration(FamilyEnvs, EnvsRefreshSeconds*time.Second)).Result()
+	if err != nil {
+		return false, fmt.Errorf("environments refresh SetNX: %s", err)
+	}
+	return claimed, nil
+}
+
+// InvalidateEnvsCached to discard the environments map, so the next instance reading it refreshes it from the DB
+func (r *RedisManager) InvalidateEnvsCached(ctx context.Context) error {
+	if err := r.Client.Del(ctx, EnvsMapKey).Err(); err != nil {
+		return fmt.Errorf("environments Del: %s", err)
+	}
+	return nil
+}
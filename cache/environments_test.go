@@ -0,0 +1,30 @@
+package cache
+
+import (
+	"testing"
+
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeEnvs(t *testing.T) {
+	env := environments.TLSEnvironment{Name: "dev", UUID: "uuid-dev", Secret: "enroll-secret", PseudonymKey: "pseudonym-key", Configuration: `{"options": {}}`}
+	env.ID = 3
+	env.Certificate = "-----BEGIN CERTIFICATE-----"
+	env.EnrollSecretPath = "enroll-path"
+	env.RemoveSecretPath = "remove-path"
+	raw, err := encodeEnvs(environments.MapEnvironments{env.Name: env, env.UUID: env})
+	assert.NoError(t, err)
+	// Secrets are never serialized
+	for _, secret := range []string{"enroll-secret", "pseudonym-key", "CERTIFICATE", "enroll-path", "remove-path"} {
+		assert.NotContains(t, string(raw), secret)
+	}
+	envsmap, err := decodeEnvs(raw)
+	assert.NoError(t, err)
+	assert.Len(t, envsmap, 2)
+	assert.Equal(t, environments.MapEnvironments{"dev": env}.WithoutSecrets()["dev"], envsmap["dev"])
+	assert.Equal(t, `{"options": {}}`, envsmap["uuid-dev"].Configuration)
+	assert.Empty(t, envsmap["uuid-dev"].Secret)
+	_, err = decodeEnvs([]byte(`{`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,383 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+const (
+	// EventsChannel is the redis channel where events are published to all the instances
+	EventsChannel = KeyNamespace + ":events"
+	// EventsPrefix to be used as prefix for the keys of the version counters of each kind of events
+	EventsPrefix = KeyNamespace + ":events:"
+	// EventsExpiration in hours to expire the version counters of events
+	EventsExpiration = 168
+	// EventsPoll is the default interval to compare the version counters, for events missed by subscribers
+	EventsPoll = 30 * time.Second
+	// EventsRetry is the default pause before subscribing again after the connection was lost
+	EventsRetry = 5 * time.Second
+	// EventsWindow is the number of versions ahead of the last contiguous version that are tracked as delivered
+	EventsWindow = 256
+)
+
+// Kinds of events
+const (
+	// EventEnvironment when an environment, its configuration, profiles or event channels change
+	EventEnvironment = "environment"
+	// EventSettings when the settings of a service change
+	EventSettings = "settings"
+	// EventQuery when an on-demand query is created, completed or deleted
+	EventQuery = "query"
+)
+
+// EventKinds are all the kinds of events
+var EventKinds = []string{EventEnvironment, EventSettings, EventQuery}
+
+// Event to notify other instances that something changed. Versions are counted by kind of event and shared by
+// all instances, so an instance that missed events knows it has to resync. Resync events are not published,
+// they are delivered when events were missed and the environment and entity are not known.
+type Event struct {
+	Kind        string    `json:"kind"`
+	Environment string    `json:"environment,omitempty"`
+	Entity      string    `json:"entity,omitempty"`
+	Version     int64     `json:"version"`
+	Emitter     string    `json:"emitter"`
+	Time        time.Time `json:"time"`
+	Resync      bool      `json:"resync,omitempty"`
+}
+
+// EventHandler to handle the events of a kind, it must be idempotent because events can be delivered again
+type EventHandler func(Event) error
+
+// EventTransport to publish and receive events and keep the version counters
+type EventTransport interface {
+	// IncrVersion to get the next version of a kind of events
+	IncrVersion(ctx context.Context, kind string) (int64, error)
+	// Versions to get the current version of each kind of events, 0 if there is none
+	Versions(ctx context.Context, kinds []string) (map[string]int64, error)
+	// Publish to send a serialized event to all subscribers
+	Publish(ctx context.Context, data []byte) error
+	// Subscribe to receive serialized events, the channel is closed when the connection is lost
+	Subscribe(ctx context.Context) (<-chan []byte, error)
+}
+
+// EventStats to expose the counters of the event bus as metrics
+type EventStats struct {
+	Published  int
+	Delivered  int
+	Duplicates int
+	Failed     int
+	Resyncs    int
+	Reconnects int
+}
+
+// EventBus to publish events to all instances and route the events received to the handlers of each kind.
+// Delivery is at least once: events missed while disconnected and events whose handler failed are delivered
+// again as resync events, when the version counters are compared after reconnecting and periodically.
+type EventBus struct {
+	Instance  string
+	Transport EventTransport
+	Retry     time.Duration
+	handlers  map[string][]EventHandler
+	// Last contiguous version of each kind delivered or resynced, and versions delivered after it
+	floor     map[string]int64
+	delivered map[string]map[int64]bool
+	stale     map[string]bool
+	synced    bool
+	stats     EventStats
+	mux       sync.Mutex
+}
+
+// NewEventBus to initialize the event bus of an instance
+func NewEventBus(instance string, transport EventTransport) *EventBus {
+	return &EventBus{
+		Instance:  instance,
+		Transport: transport,
+		Retry:     EventsRetry,
+		handlers:  make(map[string][]EventHandler),
+		floor:     make(map[string]int64),
+		delivered: make(map[string]map[int64]bool),
+		stale:     make(map[string]bool),
+	}
+}
+
+// Handle to register a handler for a kind of events, before the bus is started
+func (b *EventBus) Handle(kind string, handler EventHandler) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.handlers[kind] = append(b.handlers[kind], handler)
+}
+
+// Publish to notify all instances of a change. The version is incremented even if publishing fails, so
+// subscribers find out when they compare versions.
+func (b *EventBus) Publish(ctx context.Context, kind, environment, entity string) (Event, error) {
+	version, err := b.Transport.IncrVersion(ctx, kind)
+	if err != nil {
+		return Event{}, fmt.Errorf("error incrementing version of %s - %v", kind, err)
+	}
+	event := Event{
+		Kind:        kind,
+		Environment: environment,
+		Entity:      entity,
+		Version:     version,
+		Emitter:     b.Instance,
+		Time:        time.Now(),
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return event, fmt.Errorf("error serializing event - %v", err)
+	}
+	if err := b.Transport.Publish(ctx, data); err != nil {
+		return event, fmt.Errorf("error publishing event - %v", err)
+	}
+	b.mux.Lock()
+	b.stats.Published++
+	b.mux.Unlock()
+	return event, nil
+}
+
+// Helper to call the handlers of an event, a kind with a failed handler is resynced later
+func (b *EventBus) dispatch(event Event, handlers []EventHandler) {
+	for _, h := range handlers {
+		if err := h(event); err != nil {
+			log.Printf("error handling %s event %d - %v", event.Kind, event.Version, err)
+			b.mux.Lock()
+			b.stats.Failed++
+			b.stale[event.Kind] = true
+			b.mux.Unlock()
+		}
+	}
+}
+
+// Helper to deliver an event received, events already delivered or covered by a resync are ignored
+func (b *EventBus) deliver(data []byte) {
+	var event Event
+	if err := json.Unmarshal(data, &event); err != nil {
+		log.Printf("error parsing event %v", err)
+		return
+	}
+	b.mux.Lock()
+	handlers := b.handlers[event.Kind]
+	if len(handlers) == 0 {
+		b.mux.Unlock()
+		return
+	}
+	floor := b.floor[event.Kind]
+	seen := b.delivered[event.Kind]
+	if event.Version <= floor || seen[event.Version] {
+		b.stats.Duplicates++
+		b.mux.Unlock()
+		return
+	}
+	if seen == nil {
+		seen = make(map[int64]bool)
+		b.delivered[event.Kind] = seen
+	}
+	seen[event.Version] = true
+	for seen[floor+1] {
+		floor++
+		delete(seen, floor)
+	}
+	if len(seen) > EventsWindow {
+		// Too many versions were not delivered, the kind is resynced
+		b.stale[event.Kind] = true
+	}
+	b.floor[event.Kind] = floor
+	b.stats.Delivered++
+	b.mux.Unlock()
+	b.dispatch(event, handlers)
+}
+
+// Helper to compare the versions of the kinds with handlers, delivering a resync event for the kinds with events
+// not delivered or failed. The first time versions are only recorded.
+func (b *EventBus) resync(ctx context.Context) error {
+	b.mux.Lock()
+	kinds := make([]string, 0, len(b.handlers))
+	for k := range b.handlers {
+		kinds = append(kinds, k)
+	}
+	b.mux.Unlock()
+	if len(kinds) == 0 {
+		return nil
+	}
+	versions, err := b.Transport.Versions(ctx, kinds)
+	if err != nil {
+		return fmt.Errorf("error getting versions - %v", err)
+	}
+	for _, kind := range kinds {
+		b.mux.Lock()
+		version := versions[kind]
+		// Counters that expired or were reset are behind, they also need a resync
+		missed := b.synced && (version != b.floor[kind] || b.stale[kind])
+		b.floor[kind] = version
+		b.delivered[kind] = make(map[int64]bool)
+		b.stale[kind] = false
+		handlers := b.handlers[kind]
+		if missed {
+			b.stats.Resyncs++
+		}
+		b.mux.Unlock()
+		if missed {
+			b.dispatch(Event{Kind: kind, Version: version, Emitter: b.Instance, Time: time.Now(), Resync: true}, handlers)
+		}
+	}
+	b.mux.Lock()
+	b.synced = true
+	b.mux.Unlock()
+	return nil
+}
+
+// Helper to receive events until the connection is lost, comparing versions every poll interval
+func (b *EventBus) receive(ctx context.Context, events <-chan []byte, poll time.Duration) {
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case data, ok := <-events:
+			if !ok {
+				return
+			}
+			b.deliver(data)
+		case <-ticker.C:
+			if err := b.resync(ctx); err != nil {
+				log.Printf("error resyncing events %v", err)
+			}
+		}
+	}
+}
+
+// Start to subscribe to events and deliver them to the handlers until ctx is cancelled. When the connection is
+// lost it subscribes again and resyncs, versions are also compared every poll interval in case events are lost.
+func (b *EventBus) Start(ctx context.Context, poll time.Duration) {
+	if poll <= 0 {
+		poll = EventsPoll
+	}
+	go func() {
+		for {
+			events, err := b.Transport.Subscribe(ctx)
+			if err == nil {
+				// Events published while not subscribed are found comparing versions
+				if err := b.resync(ctx); err != nil {
+					log.Printf("error resyncing events %v", err)
+				}
+				b.receive(ctx, events, poll)
+			} else {
+				log.Printf("error subscribing to events %v", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(b.Retry):
+			}
+			b.mux.Lock()
+			b.stats.Reconnects++
+			b.mux.Unlock()
+		}
+	}()
+}
+
+// Stats to get the counters of the event bus, since the last call
+func (b *EventBus) Stats() EventStats {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	stats := b.stats
+	b.stats = EventStats{}
+	return stats
+}
+
+// RedisEvents to use redis pub/sub as transport of events, with the version counters as keys
+type RedisEvents struct {
+	Redis *RedisManager
+}
+
+// Helper to generate the key of the version counter of a kind of events
+func genEventVersionKey(kind string) string {
+	return EventsPrefix + "version:" + kind
+}
+
+// IncrEventVersion to increment the version counter of a kind of events
+func (r *RedisManager) IncrEventVersion(ctx context.Context, kind string) (int64, error) {
+	version, err := r.Client.Incr(ctx, genEventVersionKey(kind)).Result()
+	if err != nil {
+		return 0, fmt.Errorf("event version Incr: %s", err)
+	}
+	if err := r.Client.Expire(ctx, genEventVersionKey(kind), r.expiration(FamilyEvents, 0)).Err(); err != nil {
+		return 0, fmt.Errorf("event version Expire: %s", err)
+	}
+	return version, nil
+}
+
+// IncrVersion to get the next version of a kind of events
+func (e *RedisEvents) IncrVersion(ctx context.Context, kind string) (int64, error) {
+	return e.Redis.IncrEventVersion(ctx, kind)
+}
+
+// Versions to get the current version of each kind of events
+func (e *RedisEvents) Versions(ctx context.Context, kinds []string) (map[string]int64, error) {
+	versions := make(map[string]int64, len(kinds))
+	keys := make([]string, 0, len(kinds))
+	for _, k := range kinds {
+		keys = append(keys, genEventVersionKey(k))
+	}
+	values, err := e.Redis.Client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return versions, fmt.Errorf("event versions MGet: %s", err)
+	}
+	for i, v := range values {
+		if s, ok := v.(string); ok {
+			version, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return versions, fmt.Errorf("invalid version of %s - %v", kinds[i], err)
+			}
+			versions[kinds[i]] = version
+		}
+	}
+	return versions, nil
+}
+
+// Publish to send a serialized event to all subscribers
+func (e *RedisEvents) Publish(ctx context.Context, data []byte) error {
+	if err := e.Redis.Client.Publish(ctx, EventsChannel, data).Err(); err != nil {
+		return fmt.Errorf("events Publish: %s", err)
+	}
+	return nil
+}
+
+// Subscribe to receive serialized events, the channel is closed when the connection is lost
+func (e *RedisEvents) Subscribe(ctx context.Context) (<-chan []byte, error) {
+	pubsub := e.Redis.Client.Subscribe(ctx, EventsChannel)
+	// Wait for the confirmation, so no events are lost between subscribing and comparing versions
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, fmt.Errorf("events Subscribe: %s", err)
+	}
+	events := make(chan []byte)
+	go func() {
+		defer close(events)
+		defer pubsub.Close()
+		for {
+			msg, err := pubsub.ReceiveMessage(ctx)
+			if err != nil {
+				if ctx.Err() == nil && err != redis.ErrClosed {
+					log.Printf("events connection lost %v", err)
+				}
+				return
+			}
+			select {
+			case events <- []byte(msg.Payload):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
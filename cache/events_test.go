@@ -0,0 +1,229 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryEvents to test the event bus with a transport in memory, where connections can be dropped
+type memoryEvents struct {
+	mux         sync.Mutex
+	versions    map[string]int64
+	subscribers []chan []byte
+	down        bool
+}
+
+func newMemoryEvents() *memoryEvents {
+	return &memoryEvents{versions: make(map[string]int64)}
+}
+
+func (m *memoryEvents) IncrVersion(ctx context.Context, kind string) (int64, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.versions[kind]++
+	return m.versions[kind], nil
+}
+
+func (m *memoryEvents) Versions(ctx context.Context, kinds []string) (map[string]int64, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if m.down {
+		return nil, fmt.Errorf("connection refused")
+	}
+	versions := make(map[string]int64)
+	for _, k := range kinds {
+		versions[k] = m.versions[k]
+	}
+	return versions, nil
+}
+
+func (m *memoryEvents) Publish(ctx context.Context, data []byte) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	for _, s := range m.subscribers {
+		s <- data
+	}
+	return nil
+}
+
+func (m *memoryEvents) Subscribe(ctx context.Context) (<-chan []byte, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if m.down {
+		return nil, fmt.Errorf("connection refused")
+	}
+	s := make(chan []byte, 64)
+	m.subscribers = append(m.subscribers, s)
+	return s, nil
+}
+
+// Helper to drop the connections of all subscribers, events published while down are lost
+func (m *memoryEvents) drop(down bool) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.down = down
+	for _, s := range m.subscribers {
+		close(s)
+	}
+	m.subscribers = nil
+}
+
+// recorder to keep the events received by a simulated subscriber
+type recorder struct {
+	mux    sync.Mutex
+	events []Event
+	fail   int
+}
+
+func (r *recorder) handle(e Event) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.events = append(r.events, e)
+	if r.fail > 0 {
+		r.fail--
+		return fmt.Errorf("reload failed")
+	}
+	return nil
+}
+
+func (r *recorder) received() []Event {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return append([]Event{}, r.events...)
+}
+
+func TestEventBusSubscribers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	transport := newMemoryEvents()
+	admin := NewEventBus("admin-1", transport)
+	var recorders []*recorder
+	var buses []*EventBus
+	for i := 0; i < 3; i++ {
+		r := &recorder{}
+		b := NewEventBus(fmt.Sprintf("tls-%d", i), transport)
+		b.Handle(EventEnvironment, r.handle)
+		assert.NoError(t, b.resync(ctx))
+		events, err := transport.Subscribe(ctx)
+		assert.NoError(t, err)
+		go b.receive(ctx, events, time.Hour)
+		recorders = append(recorders, r)
+		buses = append(buses, b)
+	}
+	published, err := admin.Publish(ctx, EventEnvironment, "env-uuid", "config")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), published.Version)
+	// Events of kinds without handlers are ignored
+	_, err = admin.Publish(ctx, EventQuery, "env-uuid", "query_1")
+	assert.NoError(t, err)
+	for _, r := range recorders {
+		assert.Eventually(t, func() bool { return len(r.received()) == 1 }, time.Second, time.Millisecond)
+		e := r.received()[0]
+		assert.Equal(t, EventEnvironment, e.Kind)
+		assert.Equal(t, "env-uuid", e.Environment)
+		assert.Equal(t, "config", e.Entity)
+		assert.Equal(t, "admin-1", e.Emitter)
+		assert.False(t, e.Resync)
+	}
+	assert.Equal(t, 2, admin.Stats().Published)
+	// Events delivered again are ignored
+	data := []byte(`{"kind":"environment","environment":"env-uuid","version":1,"emitter":"admin-1"}`)
+	buses[0].deliver(data)
+	assert.Equal(t, 1, len(recorders[0].received()))
+	stats := buses[0].Stats()
+	assert.Equal(t, 1, stats.Delivered)
+	assert.Equal(t, 1, stats.Duplicates)
+	// Nothing was missed
+	assert.NoError(t, buses[1].resync(ctx))
+	assert.Equal(t, 1, len(recorders[1].received()))
+	assert.Equal(t, 0, buses[1].Stats().Resyncs)
+}
+
+func TestEventBusOutOfOrder(t *testing.T) {
+	ctx := context.Background()
+	r := &recorder{}
+	b := NewEventBus("tls-1", newMemoryEvents())
+	b.Handle(EventQuery, r.handle)
+	assert.NoError(t, b.resync(ctx))
+	// Two publishers can send their events in a different order than their versions
+	b.deliver([]byte(`{"kind":"query","version":2}`))
+	b.deliver([]byte(`{"kind":"query","version":1}`))
+	b.deliver([]byte(`{"kind":"query","version":2}`))
+	assert.Equal(t, 2, len(r.received()))
+	assert.Equal(t, int64(2), b.floor[EventQuery])
+	assert.Equal(t, 0, len(b.delivered[EventQuery]))
+}
+
+func TestEventBusDroppedConnection(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	transport := newMemoryEvents()
+	admin := NewEventBus("admin-1", transport)
+	envs, settings := &recorder{}, &recorder{}
+	b := NewEventBus("tls-1", transport)
+	b.Retry = 10 * time.Millisecond
+	b.Handle(EventEnvironment, envs.handle)
+	b.Handle(EventSettings, settings.handle)
+	b.Start(ctx, time.Hour)
+	assert.Eventually(t, func() bool {
+		transport.mux.Lock()
+		defer transport.mux.Unlock()
+		return len(transport.subscribers) == 1
+	}, time.Second, time.Millisecond)
+	_, err := admin.Publish(ctx, EventEnvironment, "env-uuid", "")
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool { return len(envs.received()) == 1 }, time.Second, time.Millisecond)
+	// Redis goes away, the events published meanwhile are lost
+	transport.drop(true)
+	_, err = admin.Publish(ctx, EventEnvironment, "env-uuid", "")
+	assert.NoError(t, err)
+	_, err = admin.Publish(ctx, EventEnvironment, "other-uuid", "")
+	assert.NoError(t, err)
+	_, err = admin.Publish(ctx, EventSettings, "", "tls")
+	assert.NoError(t, err)
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, 1, len(envs.received()))
+	// After reconnecting, versions are compared and each kind with missed events is resynced once
+	transport.drop(false)
+	assert.Eventually(t, func() bool { return len(envs.received()) == 2 && len(settings.received()) == 1 }, time.Second, time.Millisecond)
+	resync := envs.received()[1]
+	assert.True(t, resync.Resync)
+	assert.Equal(t, int64(3), resync.Version)
+	assert.Equal(t, "", resync.Environment)
+	assert.True(t, settings.received()[0].Resync)
+	stats := b.Stats()
+	assert.Equal(t, 1, stats.Delivered)
+	assert.Equal(t, 2, stats.Resyncs)
+	assert.True(t, stats.Reconnects >= 1)
+	// Live events continue after the resync
+	_, err = admin.Publish(ctx, EventEnvironment, "env-uuid", "")
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool { return len(envs.received()) == 3 }, time.Second, time.Millisecond)
+	assert.False(t, envs.received()[2].Resync)
+}
+
+func TestEventBusHandlerFailure(t *testing.T) {
+	ctx := context.Background()
+	transport := newMemoryEvents()
+	r := &recorder{fail: 1}
+	b := NewEventBus("tls-1", transport)
+	b.Handle(EventSettings, r.handle)
+	assert.NoError(t, b.resync(ctx))
+	version, err := transport.IncrVersion(ctx, EventSettings)
+	assert.NoError(t, err)
+	b.deliver([]byte(fmt.Sprintf(`{"kind":"settings","version":%d}`, version)))
+	// The failed event is delivered again as a resync, until the handler succeeds
+	assert.NoError(t, b.resync(ctx))
+	assert.NoError(t, b.resync(ctx))
+	received := r.received()
+	assert.Equal(t, 2, len(received))
+	assert.True(t, received[1].Resync)
+	stats := b.Stats()
+	assert.Equal(t, 1, stats.Failed)
+	assert.Equal(t, 1, stats.Resyncs)
+}
@@ -0,0 +1,88 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+const (
+	// IdempotencyPrefix to be used as prefix for the keys of idempotent requests
+	IdempotencyPrefix = KeyNamespace + ":idempotency"
+	// IdempotencyHours by default to keep the responses of idempotent requests
+	IdempotencyHours = 24
+)
+
+// IdempotencyRecord to store the state of an idempotent request and its response once completed
+type IdempotencyRecord struct {
+	Fingerprint string `json:"fingerprint"`
+	Done        bool   `json:"done"`
+	Status      int    `json:"status"`
+	ContentType string `json:"content_type"`
+	Body        []byte `json:"body"`
+}
+
+// GenIdempotencyKey to generate the key to store an idempotent request
+func GenIdempotencyKey(key string) string {
+	return IdempotencyPrefix + ":" + key
+}
+
+// ClaimIdempotency to reserve a key for a request in progress, the lock expires after the given time.
+// If the key was already claimed, the existing record is returned and the claim is false.
+func (r *RedisManager) ClaimIdempotency(key, fingerprint string, lock time.Duration) (IdempotencyRecord, bool, error) {
+	ctx := context.Background()
+	pending := IdempotencyRecord{Fingerprint: fingerprint}
+	data, err := json.Marshal(pending)
+	if err != nil {
+		return pending, false, fmt.Errorf("error serializing record - %v", err)
+	}
+	// Retry once in case the existing record expires between both operations
+	for i := 0; i < 2; i++ {
+		claimed, err := r.Client.SetNX(ctx, GenIdempotencyKey(key), data, r.expiration(FamilyIdempotency, lock)).Result()
+		if err != nil {
+			return pending, false, fmt.Errorf("idempotency SetNX: %s", err)
+		}
+		if claimed {
+			return pending, true, nil
+		}
+		raw, err := r.Client.Get(ctx, GenIdempotencyKey(key)).Bytes()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return pending, false, fmt.Errorf("idempotency Get: %s", err)
+		}
+		var existing IdempotencyRecord
+		if err := json.Unmarshal(raw, &existing); err != nil {
+			return pending, false, fmt.Errorf("error parsing record - %v", err)
+		}
+		return existing, false, nil
+	}
+	return pending, false, fmt.Errorf("idempotency key %s could not be claimed", key)
+}
+
+// CompleteIdempotency to store the response of a claimed key, it expires after the given time
+func (r *RedisManager) CompleteIdempotency(key string, record IdempotencyRecord, expire time.Duration) error {
+	record.Done = true
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("error serializing record - %v", err)
+	}
+	ctx := context.Background()
+	if err := r.Client.Set(ctx, GenIdempotencyKey(key), data, r.expiration(FamilyIdempotency, expire)).Err(); err != nil {
+		return fmt.Errorf("idempotency Set: %s", err)
+	}
+	return nil
+}
+
+// ReleaseIdempotency to remove a claimed key so the request can be retried
+func (r *RedisManager) ReleaseIdempotency(key string) error {
+	ctx := context.Background()
+	if err := r.Client.Del(ctx, GenIdempotencyKey(key)).Err(); err != nil {
+		return fmt.Errorf("idempotency Del: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,262 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// KeyNamespace is the prefix of all the keys osctrl stores in redis
+	KeyNamespace = "osctrl"
+	// FamilyStatus for cached status logs, keys are osctrl:status:<node>:<env>:<ms>
+	FamilyStatus = "status"
+	// FamilyResult for cached result logs, keys are osctrl:result:<node>:<env>:<ms>
+	FamilyResult = "result"
+	// FamilyQuery for cached results of on-demand queries, keys are osctrl:query:<name>:<node>:<ms>
+	FamilyQuery = "query"
+	// FamilyIdempotency for responses of idempotent API requests, keys are osctrl:idempotency:<key>
+	FamilyIdempotency = "idempotency"
+	// FamilyLogin for failures, delays and lockouts of logins, keys are osctrl:login:<fail|delay|lock>:<subject>
+	FamilyLogin = "login"
+	// FamilyStats for request and DB pool statistics of each instance, keys are osctrl:stats:<requests|db>:<instance>
+	FamilyStats = "stats"
+	// FamilyResponse for cached responses of API requests, keys are osctrl:response:<group>:<generation>:<hash>
+	FamilyResponse = "response"
+	// FamilyEvents for the version counters of events, keys are osctrl:events:version:<kind>
+	FamilyEvents = "events"
+	// FamilyEnvs for the environments map shared by osctrl-tls instances, keys are osctrl:envs:<map|refresh>
+	FamilyEnvs = "envs"
+	// FamilyOther for keys in redis that do not belong to osctrl
+	FamilyOther = "other"
+	// LoginExpiration in hours to expire login keys, when the policy does not set a window
+	LoginExpiration = 24
+	// StatsExpiration in hours to expire request statistics, when instances do not set it
+	StatsExpiration = 1
+	// StatsSample is the default number of keys of each family to sample for memory estimates
+	StatsSample = 50
+	// PurgeBatch is the default number of keys removed at once when purging
+	PurgeBatch = 500
+	// PurgePause is the default pause between batches when purging, so redis is not blocked
+	PurgePause = 50 * time.Millisecond
+)
+
+// KeyFamily to describe a family of keys in redis, with the prefix of its keys and how long they are kept
+type KeyFamily struct {
+	Name        string        `json:"name"`
+	Prefix      string        `json:"prefix"`
+	Expiration  time.Duration `json:"expiration"`
+	Description string        `json:"description"`
+}
+
+// KeyStats to report the keys of a family found in redis, memory is estimated from a sample of keys
+type KeyStats struct {
+	Family       string `json:"family"`
+	Prefix       string `json:"prefix"`
+	Keys         int64  `json:"keys"`
+	Sampled      int    `json:"sampled"`
+	MemoryBytes  int64  `json:"memory_bytes"`
+	NoExpiration int    `json:"no_expiration"`
+}
+
+// CacheStats to report the keyspace of redis by family of keys
+type CacheStats struct {
+	Keys     int64      `json:"keys"`
+	Families []KeyStats `json:"families"`
+}
+
+// Helper to get the duration of a configured number of hours, with a default if not configured
+func hoursOrDefault(hours, def int) time.Duration {
+	if hours <= 0 {
+		hours = def
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// Helper to get the duration of a configured number of seconds, with a default if not configured
+func secondsOrDefault(seconds, def int) time.Duration {
+	if seconds <= 0 {
+		seconds = def
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// Families to get all the families of keys osctrl stores in redis, with their configured expiration
+func (r *RedisManager) Families() []KeyFamily {
+	config := JSONConfigurationRedis{}
+	if r != nil && r.Config != nil {
+		config = *r.Config
+	}
+	return []KeyFamily{
+		{Name: FamilyStatus, Prefix: HashKeyStatus + ":", Expiration: hoursOrDefault(config.StatusExpirationHours, StatusExpiration), Description: "Status logs of nodes"},
+		{Name: FamilyResult, Prefix: HashKeyResult + ":", Expiration: hoursOrDefault(config.ResultExpirationHours, ResultExpiration), Description: "Result logs of nodes"},
+		{Name: FamilyQuery, Prefix: HashKeyQuery + ":", Expiration: hoursOrDefault(config.QueryExpirationHours, QueryExpiration), Description: "Results of on-demand queries"},
+		{Name: FamilyIdempotency, Prefix: IdempotencyPrefix + ":", Expiration: hoursOrDefault(config.IdempotencyExpirationHours, IdempotencyHours), Description: "Responses of idempotent API requests"},
+		{Name: FamilyLogin, Prefix: LoginPrefix + ":", Expiration: hoursOrDefault(config.LoginExpirationHours, LoginExpiration), Description: "Failures, delays and lockouts of logins"},
+		{Name: FamilyStats, Prefix: StatsPrefix, Expiration: hoursOrDefault(config.StatsExpirationHours, StatsExpiration), Description: "Request and DB pool statistics of instances"},
+		{Name: FamilyResponse, Prefix: ResponsePrefix + ":", Expiration: secondsOrDefault(config.ResponseExpirationSeconds, ResponseSeconds), Description: "Cached responses of API requests"},
+		{Name: FamilyEvents, Prefix: EventsPrefix, Expiration: hoursOrDefault(config.EventsExpirationHours, EventsExpiration), Description: "Version counters of events between instances"},
+		{Name: FamilyEnvs, Prefix: EnvsPrefix, Expiration: secondsOrDefault(config.EnvsExpirationSeconds, EnvsSeconds), Description: "Environments map shared by TLS instances"},
+	}
+}
+
+// Family to get a family of keys by name
+func (r *RedisManager) Family(name string) (KeyFamily, error) {
+	for _, f := range r.Families() {
+		if f.Name == name {
+			return f, nil
+		}
+	}
+	return KeyFamily{}, fmt.Errorf("unknown family %s", name)
+}
+
+// Helper to get the expiration of a key of a family. Keys are never written without expiration, so
+// when the caller does not provide one, the configured expiration of the family is used.
+func (r *RedisManager) expiration(family string, requested time.Duration) time.Duration {
+	if requested > 0 {
+		return requested
+	}
+	f, err := r.Family(family)
+	if err != nil {
+		return time.Hour
+	}
+	return f.Expiration
+}
+
+// FamilyOf to get the family of a key, keys not stored by osctrl are in the other family
+func FamilyOf(families []KeyFamily, key string) string {
+	for _, f := range families {
+		if strings.HasPrefix(key, f.Prefix) {
+			return f.Name
+		}
+	}
+	return FamilyOther
+}
+
+// ValidPurgeMatch to check that a match expression only selects keys of one family of osctrl
+func ValidPurgeMatch(families []KeyFamily, match string) bool {
+	for _, f := range families {
+		if strings.HasPrefix(match, f.Prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Stats to count the keys of each family in redis, sampling up to sample keys of each family
+// to estimate the memory they use and find keys without expiration
+func (r *RedisManager) Stats(sample int) (CacheStats, error) {
+	if sample <= 0 {
+		sample = StatsSample
+	}
+	ctx := context.Background()
+	families := r.Families()
+	byFamily := make(map[string]*KeyStats)
+	for _, f := range families {
+		byFamily[f.Name] = &KeyStats{Family: f.Name, Prefix: f.Prefix}
+	}
+	byFamily[FamilyOther] = &KeyStats{Family: FamilyOther}
+	samples := make(map[string][]string)
+	stats := CacheStats{Families: []KeyStats{}}
+	iter := r.Client.Scan(ctx, 0, "*", int64(PurgeBatch)).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		family := FamilyOf(families, key)
+		byFamily[family].Keys++
+		stats.Keys++
+		if len(samples[family]) < sample {
+			samples[family] = append(samples[family], key)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return stats, fmt.Errorf("stats Scan: %s", err)
+	}
+	for family, keys := range samples {
+		var sampled, memory int64
+		for _, key := range keys {
+			// Keys can expire while sampling
+			used, err := r.Client.MemoryUsage(ctx, key).Result()
+			if err != nil {
+				continue
+			}
+			ttl, err := r.Client.TTL(ctx, key).Result()
+			if err != nil {
+				continue
+			}
+			sampled++
+			memory += used
+			if ttl < 0 {
+				byFamily[family].NoExpiration++
+			}
+		}
+		byFamily[family].Sampled = int(sampled)
+		if sampled > 0 {
+			byFamily[family].MemoryBytes = memory * byFamily[family].Keys / sampled
+		}
+	}
+	for _, s := range byFamily {
+		stats.Families = append(stats.Families, *s)
+	}
+	sort.Slice(stats.Families, func(i, j int) bool {
+		return stats.Families[i].MemoryBytes > stats.Families[j].MemoryBytes || (stats.Families[i].MemoryBytes == stats.Families[j].MemoryBytes && stats.Families[i].Family < stats.Families[j].Family)
+	})
+	return stats, nil
+}
+
+// Count to count the keys matching an expression, scanning in batches
+func (r *RedisManager) Count(ctx context.Context, match string, batch int, pause time.Duration) (int64, error) {
+	var count int64
+	err := r.scanBatches(ctx, match, batch, pause, func(keys []string) error {
+		count += int64(len(keys))
+		return nil
+	})
+	return count, err
+}
+
+// Purge to remove the keys of osctrl matching an expression, with UNLINK in batches and a pause
+// between batches so redis keeps serving other clients
+func (r *RedisManager) Purge(ctx context.Context, match string, batch int, pause time.Duration) (int64, error) {
+	if !ValidPurgeMatch(r.Families(), match) {
+		return 0, fmt.Errorf("match %s is not a family of keys of osctrl", match)
+	}
+	var removed int64
+	err := r.scanBatches(ctx, match, batch, pause, func(keys []string) error {
+		n, err := r.Client.Unlink(ctx, keys...).Result()
+		if err != nil {
+			return fmt.Errorf("purge Unlink: %s", err)
+		}
+		removed += n
+		return nil
+	})
+	return removed, err
+}
+
+// Helper to scan keys matching an expression in batches, pausing between batches
+func (r *RedisManager) scanBatches(ctx context.Context, match string, batch int, pause time.Duration, fn func(keys []string) error) error {
+	if batch <= 0 {
+		batch = PurgeBatch
+	}
+	var cursor uint64
+	for {
+		keys, next, err := r.Client.Scan(ctx, cursor, match, int64(batch)).Result()
+		if err != nil {
+			return fmt.Errorf("Scan: %s", err)
+		}
+		if len(keys) > 0 {
+			if err := fn(keys); err != nil {
+				return err
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pause):
+		}
+	}
+}
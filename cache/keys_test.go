@@ -0,0 +1,144 @@
+package cache
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Commands that write a key with its expiration, and the position of the expiration
+var expiringWrites = map[string]int{
+	"Set":   3,
+	"SetNX": 3,
+	"SetEX": 3,
+}
+
+// Commands that write a key without expiration
+var plainWrites = map[string]bool{
+	"Incr": true, "IncrBy": true, "IncrByFloat": true, "Decr": true, "DecrBy": true,
+	"Append": true, "SetRange": true, "GetSet": true, "MSet": true, "MSetNX": true,
+	"HSet": true, "HMSet": true, "HSetNX": true, "HIncrBy": true,
+	"LPush": true, "RPush": true, "SAdd": true, "ZAdd": true, "PFAdd": true,
+	"Rename": true, "Copy": true, "Persist": true,
+}
+
+// Functions allowed to use commands without expiration, they must set it with Expire
+var plainWritesAllowed = map[string]string{
+	"ReserveLogin":        "the counter gets the expiration of the window when it has none",
+	"InvalidateResponses": "the generation gets its expiration after each increment",
+	"IncrEventVersion":    "the version counter gets its expiration after each increment",
+}
+
+// Helper to check if an expression is a call to the expiration helper
+func isExpirationCall(e ast.Expr) bool {
+	call, ok := e.(*ast.CallExpr)
+	if !ok {
+		return false
+	}
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	return ok && sel.Sel.Name == "expiration"
+}
+
+func TestWritesExpire(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, ".", func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	assert.NoError(t, err)
+	checked := 0
+	for _, pkg := range pkgs {
+		for _, file := range pkg.Files {
+			for _, decl := range file.Decls {
+				fn, ok := decl.(*ast.FuncDecl)
+				if !ok || fn.Body == nil {
+					continue
+				}
+				expires := false
+				var plain []string
+				ast.Inspect(fn.Body, func(n ast.Node) bool {
+					call, ok := n.(*ast.CallExpr)
+					if !ok {
+						return true
+					}
+					sel, ok := call.Fun.(*ast.SelectorExpr)
+					if !ok {
+						return true
+					}
+					name := sel.Sel.Name
+					if pos, ok := expiringWrites[name]; ok && len(call.Args) > pos {
+						checked++
+						assert.True(t, isExpirationCall(call.Args[pos]), "%s in %s must use the expiration of its family", name, fset.Position(call.Pos()))
+					}
+					if name == "Expire" && len(call.Args) > 2 && isExpirationCall(call.Args[2]) {
+						expires = true
+					}
+					if plainWrites[name] {
+						plain = append(plain, name)
+					}
+					return true
+				})
+				if len(plain) == 0 {
+					continue
+				}
+				checked++
+				_, allowed := plainWritesAllowed[fn.Name.Name]
+				assert.True(t, allowed, "%s writes keys without expiration with %v", fn.Name.Name, plain)
+				assert.True(t, expires, "%s must set the expiration of keys written with %v", fn.Name.Name, plain)
+			}
+		}
+	}
+	// All the write paths of the package were found
+	assert.Equal(t, 13, checked)
+}
+
+func TestFamilies(t *testing.T) {
+	r := &RedisManager{Config: &JSONConfigurationRedis{StatusExpirationHours: 2}}
+	families := r.Families()
+	prefixes := make(map[string]bool)
+	for _, f := range families {
+		assert.True(t, strings.HasPrefix(f.Prefix, KeyNamespace+":"), f.Name)
+		assert.True(t, strings.HasSuffix(f.Prefix, ":"), f.Name)
+		assert.True(t, f.Expiration > 0, f.Name)
+		assert.False(t, prefixes[f.Prefix], f.Name)
+		prefixes[f.Prefix] = true
+	}
+	// Expirations not configured use the defaults
+	assert.Equal(t, 2*time.Hour, r.expiration(FamilyStatus, 0))
+	assert.Equal(t, time.Duration(ResultExpiration)*time.Hour, r.expiration(FamilyResult, 0))
+	assert.Equal(t, time.Duration(LoginExpiration)*time.Hour, r.expiration(FamilyLogin, -time.Second))
+	assert.Equal(t, time.Minute, r.expiration(FamilyLogin, time.Minute))
+	assert.Equal(t, time.Hour, r.expiration("unknown", 0))
+	// Keys of each family
+	assert.Equal(t, FamilyStatus, FamilyOf(families, GenStatusKey("node", "dev")))
+	assert.Equal(t, FamilyResult, FamilyOf(families, GenResultKey("node", "dev")))
+	assert.Equal(t, FamilyQuery, FamilyOf(families, GenQueryKey("node", "query_1")))
+	assert.Equal(t, FamilyIdempotency, FamilyOf(families, GenIdempotencyKey("abc")))
+	assert.Equal(t, FamilyLogin, FamilyOf(families, genLoginLockKey("user:admin")))
+	assert.Equal(t, FamilyStats, FamilyOf(families, RequestStatsPrefix+"tls-1"))
+	assert.Equal(t, FamilyStats, FamilyOf(families, PoolStatsPrefix+"tls:tls-1"))
+	assert.Equal(t, FamilyResponse, FamilyOf(families, GenResponseKey(ResponseGroupNodes, 3, "abc")))
+	assert.Equal(t, FamilyEvents, FamilyOf(families, genEventVersionKey(EventQuery)))
+	assert.Equal(t, FamilyEnvs, FamilyOf(families, EnvsMapKey))
+	assert.Equal(t, FamilyOther, FamilyOf(families, "status:node:dev:1"))
+	// Purges only select keys of one family
+	assert.True(t, ValidPurgeMatch(families, "osctrl:status:*"))
+	assert.True(t, ValidPurgeMatch(families, "osctrl:query:query_1:*"))
+	assert.False(t, ValidPurgeMatch(families, "osctrl:*"))
+	assert.False(t, ValidPurgeMatch(families, "*"))
+}
+
+func TestParseQueryKey(t *testing.T) {
+	name, node, unixtime := ParseQueryKey(HashKeyQuery + ":query_1:NODE-1:1700000000")
+	assert.Equal(t, "query_1", name)
+	assert.Equal(t, "NODE-1", node)
+	assert.Equal(t, 1700000000, unixtime)
+	name, node, _ = ParseQueryKey("broken")
+	assert.Equal(t, "broken", name)
+	assert.Equal(t, "", node)
+}
@@ -0,0 +1,148 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	redis "github.com/go-redis/redis/v8"
+	"github.com/jmpsec/osctrl/users"
+)
+
+const (
+	// LoginPrefix to be used as prefix for the keys of login attempts
+	LoginPrefix = KeyNamespace + ":login"
+)
+
+// Helpers to generate the keys for the failures, the delay and the lockout of a login subject
+func genLoginFailKey(key string) string {
+	return LoginPrefix + ":fail:" + key
+}
+
+func genLoginDelayKey(key string) string {
+	return LoginPrefix + ":delay:" + key
+}
+
+func genLoginLockKey(key string) string {
+	return LoginPrefix + ":lock:" + key
+}
+
+// LoginBlocks to get the delay and the lockout of each key, in the same order
+func (r *RedisManager) LoginBlocks(keys []string) ([]users.LoginBlock, error) {
+	blocks := make([]users.LoginBlock, len(keys))
+	if len(keys) == 0 {
+		return blocks, nil
+	}
+	var rkeys []string
+	for _, k := range keys {
+		rkeys = append(rkeys, genLoginDelayKey(k), genLoginLockKey(k))
+	}
+	values, err := r.Client.MGet(context.Background(), rkeys...).Result()
+	if err != nil {
+		return blocks, fmt.Errorf("login MGet: %s", err)
+	}
+	for i := range keys {
+		blocks[i] = parseLoginBlock(values[2*i], values[2*i+1])
+	}
+	return blocks, nil
+}
+
+// Retries of a reservation when a concurrent attempt changes the keys of the same subject
+const loginReserveRetries = 5
+
+// Helper to parse the delay and the lockout of a subject from the values of their keys
+func parseLoginBlock(delay, lock interface{}) users.LoginBlock {
+	var block users.LoginBlock
+	if v, ok := delay.(string); ok {
+		if until, err := strconv.ParseInt(v, 10, 64); err == nil {
+			block.DelayUntil = time.Unix(until, 0)
+		}
+	}
+	if v, ok := lock.(string); ok {
+		if id, err := strconv.ParseUint(v, 10, 64); err == nil {
+			block.LockoutID = uint(id)
+		}
+	}
+	return block
+}
+
+// ReserveLogin to count an attempt of a key before its credentials are checked, unless it is delayed or locked out.
+// The check, the counter and the delay for the next attempt are written in one transaction watching the keys,
+// so concurrent attempts can not pass the same check. Returns 0 attempts if the key is blocked.
+func (r *RedisManager) ReserveLogin(key string, window time.Duration, delay func(attempts int64) time.Duration) (int64, users.LoginBlock, error) {
+	ctx := context.Background()
+	failKey := genLoginFailKey(key)
+	delayKey := genLoginDelayKey(key)
+	lockKey := genLoginLockKey(key)
+	var attempts int64
+	var block users.LoginBlock
+	reserve := func(tx *redis.Tx) error {
+		values, err := tx.MGet(ctx, failKey, delayKey, lockKey).Result()
+		if err != nil {
+			return err
+		}
+		ttl, err := tx.TTL(ctx, failKey).Result()
+		if err != nil {
+			return err
+		}
+		attempts = 0
+		block = parseLoginBlock(values[1], values[2])
+		// The delay key expires with the delay, so it blocks while it exists
+		if values[1] != nil || block.LockoutID != 0 {
+			if block.DelayUntil.IsZero() && block.LockoutID == 0 {
+				block.DelayUntil = time.Now().Add(time.Second)
+			}
+			return nil
+		}
+		if v, ok := values[0].(string); ok {
+			attempts, _ = strconv.ParseInt(v, 10, 64)
+		}
+		attempts++
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Incr(ctx, failKey)
+			if attempts == 1 || ttl < 0 {
+				pipe.Expire(ctx, failKey, r.expiration(FamilyLogin, window))
+			}
+			if d := delay(attempts); d > 0 {
+				pipe.Set(ctx, delayKey, time.Now().Add(d).Unix(), r.expiration(FamilyLogin, d))
+			}
+			return nil
+		})
+		return err
+	}
+	for i := 0; i < loginReserveRetries; i++ {
+		err := r.Client.Watch(ctx, reserve, failKey, delayKey, lockKey)
+		if err == redis.TxFailedErr {
+			continue
+		}
+		if err != nil {
+			return 0, block, fmt.Errorf("login Watch: %s", err)
+		}
+		return attempts, block, nil
+	}
+	// Too many concurrent attempts of the same key, they are delayed instead of counted
+	return 0, users.LoginBlock{DelayUntil: time.Now().Add(time.Second)}, nil
+}
+
+// SetLoginLockout to keep the lockout of a key for a duration, clearing its failures
+func (r *RedisManager) SetLoginLockout(key string, id uint, duration time.Duration) error {
+	ctx := context.Background()
+	_, err := r.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, genLoginLockKey(key), id, r.expiration(FamilyLogin, duration))
+		pipe.Del(ctx, genLoginFailKey(key), genLoginDelayKey(key))
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("login TxPipelined: %s", err)
+	}
+	return nil
+}
+
+// ResetLogin to clear the failures, the delay and the lockout of a key
+func (r *RedisManager) ResetLogin(key string) error {
+	if err := r.Client.Del(context.Background(), genLoginFailKey(key), genLoginDelayKey(key), genLoginLockKey(key)).Err(); err != nil {
+		return fmt.Errorf("login Del: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+const (
+	// ResponsePrefix to be used as prefix for the keys of cached API responses
+	ResponsePrefix = KeyNamespace + ":response"
+	// ResponseSeconds by default to keep cached API responses
+	ResponseSeconds = 10
+	// ResponseGenerationHours to keep the generation of a group of responses after it was invalidated
+	ResponseGenerationHours = 24
+	// ResponseGroupNodes for cached responses with nodes
+	ResponseGroupNodes = "nodes"
+	// ResponseGroupEnvironments for cached responses with environments
+	ResponseGroupEnvironments = "environments"
+)
+
+// CachedResponse to store the response of an API request
+type CachedResponse struct {
+	Status      int    `json:"status"`
+	ContentType string `json:"content_type"`
+	Body        []byte `json:"body"`
+}
+
+// Helper to generate the key with the current generation of a group of responses
+func genResponseGenerationKey(group string) string {
+	return ResponsePrefix + ":gen:" + group
+}
+
+// GenResponseKey to generate the key to store a response of a group, with the generation it was read from
+func GenResponseKey(group string, generation int64, hash string) string {
+	return ResponsePrefix + ":" + group + ":" + strconv.FormatInt(generation, 10) + ":" + hash
+}
+
+// ResponseGeneration to get the current generation of a group of responses. Responses are stored with the
+// generation they were read from, so responses of older generations are never returned.
+func (r *RedisManager) ResponseGeneration(group string) (int64, error) {
+	generation, err := r.Client.Get(context.Background(), genResponseGenerationKey(group)).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("response generation Get: %s", err)
+	}
+	return generation, nil
+}
+
+// GetResponse to get a cached response of a group and generation, false if it is not cached
+func (r *RedisManager) GetResponse(group string, generation int64, hash string) (CachedResponse, bool, error) {
+	var response CachedResponse
+	raw, err := r.Client.Get(context.Background(), GenResponseKey(group, generation, hash)).Bytes()
+	if err == redis.Nil {
+		return response, false, nil
+	}
+	if err != nil {
+		return response, false, fmt.Errorf("response Get: %s", err)
+	}
+	if err := json.Unmarshal(raw, &response); err != nil {
+		return response, false, fmt.Errorf("error parsing response - %v", err)
+	}
+	return response, true, nil
+}
+
+// SetResponse to cache a response of a group and generation, it expires after the given time
+func (r *RedisManager) SetResponse(group string, generation int64, hash string, response CachedResponse, expire time.Duration) error {
+	data, err := json.Marshal(response)
+	if err != nil {
+		return fmt.Errorf("error serializing response - %v", err)
+	}
+	if err := r.Client.Set(context.Background(), GenResponseKey(group, generation, hash), data, r.expiration(FamilyResponse, expire)).Err(); err != nil {
+		return fmt.Errorf("response Set: %s", err)
+	}
+	return nil
+}
+
+// InvalidateResponses to discard all the cached responses of a group, by moving it to a new generation
+func (r *RedisManager) InvalidateResponses(group string) error {
+	ctx := context.Background()
+	if err := r.Client.Incr(ctx, genResponseGenerationKey(group)).Err(); err != nil {
+		return fmt.Errorf("response generation Incr: %s", err)
+	}
+	expire := time.Duration(ResponseGenerationHours) * time.Hour
+	if err := r.Client.Expire(ctx, genResponseGenerationKey(group), r.expiration(FamilyResponse, expire)).Err(); err != nil {
+		return fmt.Errorf("response generation Expire: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jmpsec/osctrl/backend"
+	"github.com/jmpsec/osctrl/metrics"
+)
+
+const (
+	// StatsPrefix to be used as prefix for the keys of all statistics of instances
+	StatsPrefix = KeyNamespace + ":stats:"
+	// RequestStatsPrefix to be used as prefix for the keys of request statistics of each instance
+	RequestStatsPrefix = StatsPrefix + "requests:"
+	// PoolStatsPrefix to be used as prefix for the keys of DB pool statistics of each instance
+	PoolStatsPrefix = StatsPrefix + "db:"
+)
+
+// SetRequestStats to store the request statistics of an instance, they expire if the instance stops reporting
+func (r *RedisManager) SetRequestStats(snapshot metrics.RequestSnapshot, expiration time.Duration) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("error serializing request stats - %v", err)
+	}
+	if err := r.Client.Set(context.Background(), RequestStatsPrefix+snapshot.Instance, data, r.expiration(FamilyStats, expiration)).Err(); err != nil {
+		return fmt.Errorf("request stats Set: %s", err)
+	}
+	return nil
+}
+
+// GetRequestStats to get the request statistics of all the instances reporting, sorted by instance
+func (r *RedisManager) GetRequestStats() ([]metrics.RequestSnapshot, error) {
+	ctx := context.Background()
+	snapshots := []metrics.RequestSnapshot{}
+	var keys []string
+	iter := r.Client.Scan(ctx, 0, RequestStatsPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return snapshots, fmt.Errorf("request stats Scan: %s", err)
+	}
+	if len(keys) == 0 {
+		return snapshots, nil
+	}
+	values, err := r.Client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return snapshots, fmt.Errorf("request stats MGet: %s", err)
+	}
+	for _, v := range values {
+		// Keys can expire between both operations
+		data, ok := v.(string)
+		if !ok {
+			continue
+		}
+		var snapshot metrics.RequestSnapshot
+		if err := json.Unmarshal([]byte(data), &snapshot); err != nil {
+			return snapshots, fmt.Errorf("error parsing request stats - %v", err)
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Instance < snapshots[j].Instance
+	})
+	return snapshots, nil
+}
+
+// SetPoolStats to store the DB pool statistics of an instance, they expire if the instance stops reporting
+func (r *RedisManager) SetPoolStats(stats backend.InstancePoolStats, expiration time.Duration) error {
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return fmt.Errorf("error serializing pool stats - %v", err)
+	}
+	key := PoolStatsPrefix + stats.Service + ":" + stats.Instance
+	if err := r.Client.Set(context.Background(), key, data, r.expiration(FamilyStats, expiration)).Err(); err != nil {
+		return fmt.Errorf("pool stats Set: %s", err)
+	}
+	return nil
+}
+
+// GetPoolStats to get the DB pool statistics of all the instances reporting, sorted by service and instance
+func (r *RedisManager) GetPoolStats() ([]backend.InstancePoolStats, error) {
+	ctx := context.Background()
+	stats := []backend.InstancePoolStats{}
+	var keys []string
+	iter := r.Client.Scan(ctx, 0, PoolStatsPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return stats, fmt.Errorf("pool stats Scan: %s", err)
+	}
+	if len(keys) == 0 {
+		return stats, nil
+	}
+	values, err := r.Client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return stats, fmt.Errorf("pool stats MGet: %s", err)
+	}
+	for _, v := range values {
+		// Keys can expire between both operations
+		data, ok := v.(string)
+		if !ok {
+			continue
+		}
+		var s backend.InstancePoolStats
+		if err := json.Unmarshal([]byte(data), &s); err != nil {
+			return stats, fmt.Errorf("error parsing pool stats - %v", err)
+		}
+		stats = append(stats, s)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Service != stats[j].Service {
+			return stats[i].Service < stats[j].Service
+		}
+		return stats[i].Instance < stats[j].Instance
+	})
+	return stats, nil
+}
@@ -49,13 +49,16 @@ func GenQueryNameMatch(name string) string {
 
 // ParseQueryKey to parse the key used to cache queries
 func ParseQueryKey(key string) (string, string, int) {
-	parsed := strings.Split(key, ":")
-	// parsed[1] is query name
-	queryName := parsed[1]
-	// parsed[2] is hostIdentifier
-	hostIdentifier := parsed[2]
-	// parsed[3] is unixtime
-	i, err := strconv.Atoi(parsed[3])
+	parsed := strings.Split(strings.TrimPrefix(key, HashKeyQuery+":"), ":")
+	if len(parsed) < 3 {
+		return key, "", int(time.Now().Unix())
+	}
+	// parsed[0] is query name
+	queryName := parsed[0]
+	// parsed[1] is hostIdentifier
+	hostIdentifier := parsed[1]
+	// parsed[2] is unixtime
+	i, err := strconv.Atoi(parsed[2])
 	if err != nil {
 		i = int(time.Now().Unix())
 	}
@@ -1,6 +1,7 @@
 package carves
 
 import (
+	"context"
 	"encoding/base64"
 	"fmt"
 	"log"
@@ -62,6 +63,8 @@ type Carves struct {
 	DB     *gorm.DB
 	S3     *CarverS3
 	Carver string
+	// OnComplete is called with every carve once it is completed, if set
+	OnComplete func(CarvedFile)
 }
 
 // CreateFileCarves to initialize the carves struct and tables
@@ -76,6 +79,18 @@ func CreateFileCarves(backend *gorm.DB, carverType string, s3 *CarverS3) *Carves
 	if err := backend.AutoMigrate(&CarvedBlock{}); err != nil {
 		log.Fatalf("Failed to AutoMigrate table (carved_blocks): %v", err)
 	}
+	// table carve_limits
+	if err := backend.AutoMigrate(&CarveLimits{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (carve_limits): %v", err)
+	}
+	// table carve_rejections
+	if err := backend.AutoMigrate(&CarveRejection{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (carve_rejections): %v", err)
+	}
+	// table carve_path_overrides
+	if err := backend.AutoMigrate(&CarvePathOverride{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (carve_path_overrides): %v", err)
+	}
 	return c
 }
 
@@ -84,12 +99,23 @@ func (c *Carves) CreateCarve(carve CarvedFile) error {
 	return c.DB.Create(&carve).Error // can be nil or err
 }
 
-// InitCarve to initialize an scheduled carve
+// InitCarve to initialize an scheduled carve, only the carve of the node when osquery sends its carve id
 func (c *Carves) InitCarve(req types.CarveInitRequest, sessionid string) error {
 	carves, err := c.GetByRequest(req.RequestID)
 	if err != nil {
 		return fmt.Errorf("getCarveByRequest %v", err)
 	}
+	if req.CarveID != "" {
+		var own []CarvedFile
+		for _, carve := range carves {
+			if carve.CarveID == req.CarveID {
+				own = append(own, carve)
+			}
+		}
+		if len(own) > 0 {
+			carves = own
+		}
+	}
 	for _, carve := range carves {
 		toUpdate := map[string]interface{}{
 			"carve_size":   req.CarveSize,
@@ -98,6 +124,7 @@ func (c *Carves) InitCarve(req types.CarveInitRequest, sessionid string) error {
 			"session_id":   sessionid,
 			"status":       StatusInProgress,
 			"carver":       c.Carver,
+			"init_at":      time.Now(),
 		}
 		if err := c.DB.Model(&carve).Updates(toUpdate).Error; err != nil {
 			return err
@@ -117,7 +144,12 @@ func (c *Carves) CheckCarve(sessionid, requestid string) bool {
 
 // GetCarve to verify a session belong to a carve
 func (c *Carves) GetCheckCarve(sessionid, requestid string) (CarvedFile, error) {
-	carve, err := c.GetBySession(sessionid)
+	return c.GetCheckCarveCtx(context.Background(), sessionid, requestid)
+}
+
+// GetCheckCarveCtx to verify a session belong to a carve, aborting if ctx is cancelled
+func (c *Carves) GetCheckCarveCtx(ctx context.Context, sessionid, requestid string) (CarvedFile, error) {
+	carve, err := c.GetBySessionCtx(ctx, sessionid)
 	if err != nil {
 		return carve, fmt.Errorf("GetBySession %v", err)
 	}
@@ -148,17 +180,25 @@ func (c *Carves) InitateBlock(env, uuid, requestid, sessionid, data string, bloc
 	return res
 }
 
-// CreateBlock to create a new block for a carve
+// CreateBlock to create a new block for a carve, the row is only created once the data is stored
+// so received blocks always match what can be reassembled. Blocks already stored are skipped
 func (c *Carves) CreateBlock(block CarvedBlock, uuid, data string) error {
+	var existing int64
+	if err := c.DB.Model(&CarvedBlock{}).Where("session_id = ? AND block_id = ?", block.SessionID, block.BlockID).Count(&existing).Error; err != nil {
+		return err
+	}
+	if existing > 0 {
+		return nil
+	}
 	switch c.Carver {
 	case settings.CarverDB:
 		return c.DB.Create(&block).Error // can be nil or err
 	case settings.CarverS3:
 		if c.S3 != nil {
-			if err := c.DB.Create(&block).Error; err != nil {
+			if err := c.S3.Upload(block, uuid, data); err != nil {
 				return err
 			}
-			return c.S3.Upload(block, uuid, data)
+			return c.DB.Create(&block).Error
 		}
 		return fmt.Errorf("S3 carver not initialized")
 	}
@@ -202,8 +242,13 @@ func (c *Carves) GetByCarve(carveid string) (CarvedFile, error) {
 
 // GetBySession to get a carve by session_id
 func (c *Carves) GetBySession(sessionid string) (CarvedFile, error) {
+	return c.GetBySessionCtx(context.Background(), sessionid)
+}
+
+// GetBySessionCtx to get a carve by session_id, aborting if ctx is cancelled
+func (c *Carves) GetBySessionCtx(ctx context.Context, sessionid string) (CarvedFile, error) {
 	var carve CarvedFile
-	if err := c.DB.Where("session_id = ?", sessionid).Find(&carve).Error; err != nil {
+	if err := c.DB.WithContext(ctx).Where("session_id = ?", sessionid).Find(&carve).Error; err != nil {
 		return carve, err
 	}
 	return carve, nil
@@ -229,17 +274,71 @@ func (c *Carves) GetBlocks(sessionid string) ([]CarvedBlock, error) {
 
 // GetByQuery to get a carve by query name
 func (c *Carves) GetByQuery(name string, env uint) ([]CarvedFile, error) {
+	return c.GetByQueryCtx(context.Background(), name, env)
+}
+
+// GetByQueryCtx to get a carve by query name, aborting if ctx is cancelled
+func (c *Carves) GetByQueryCtx(ctx context.Context, name string, env uint) ([]CarvedFile, error) {
+	var carves []CarvedFile
+	if err := c.DB.WithContext(ctx).Where("query_name = ? AND environment_id = ?", name, env).Find(&carves).Error; err != nil {
+		return carves, err
+	}
+	return carves, nil
+}
+
+// GetByQueries to get the carves of several queries, for the storage of a case
+func (c *Carves) GetByQueries(names []string) ([]CarvedFile, error) {
 	var carves []CarvedFile
-	if err := c.DB.Where("query_name = ? AND environment_id = ?", name, env).Find(&carves).Error; err != nil {
+	if len(names) == 0 {
+		return carves, nil
+	}
+	if err := c.DB.Where("query_name IN ?", names).Find(&carves).Error; err != nil {
 		return carves, err
 	}
 	return carves, nil
 }
 
+// Footprint to get the total bytes and number of the carves of several queries
+func (c *Carves) Footprint(names []string) (int64, int64, error) {
+	if len(names) == 0 {
+		return 0, 0, nil
+	}
+	var result struct {
+		Bytes  int64
+		Carves int64
+	}
+	if err := c.DB.Model(&CarvedFile{}).Select("COALESCE(SUM(carve_size), 0) AS bytes, COUNT(*) AS carves").Where("query_name IN ?", names).Scan(&result).Error; err != nil {
+		return 0, 0, err
+	}
+	return result.Bytes, result.Carves, nil
+}
+
+// SizeByEnvironment to get the bytes of the carves stored in the DB by environment ID, carved files are
+// one row for each carve, so they are summed instead of the blocks
+func (c *Carves) SizeByEnvironment() (map[uint]int64, error) {
+	res := make(map[uint]int64)
+	var sizes []struct {
+		EnvironmentID uint
+		Bytes         int64
+	}
+	if err := c.DB.Model(&CarvedFile{}).Select("environment_id, COALESCE(SUM(carve_size), 0) AS bytes").Where("carver = ?", settings.CarverDB).Group("environment_id").Scan(&sizes).Error; err != nil {
+		return res, err
+	}
+	for _, s := range sizes {
+		res[s.EnvironmentID] = s.Bytes
+	}
+	return res, nil
+}
+
 // GetByEnv to get carves by environment
 func (c *Carves) GetByEnv(env uint) ([]CarvedFile, error) {
+	return c.GetByEnvCtx(context.Background(), env)
+}
+
+// GetByEnvCtx to get carves by environment, aborting if ctx is cancelled
+func (c *Carves) GetByEnvCtx(ctx context.Context, env uint) ([]CarvedFile, error) {
 	var carves []CarvedFile
-	if err := c.DB.Where("environment_id = ?", env).Find(&carves).Error; err != nil {
+	if err := c.DB.WithContext(ctx).Where("environment_id = ?", env).Find(&carves).Error; err != nil {
 		return carves, err
 	}
 	return carves, nil
@@ -260,6 +359,7 @@ func (c *Carves) ChangeStatus(status, sessionid string) error {
 	if err != nil {
 		return fmt.Errorf("getCarveBySessionID %v", err)
 	}
+	previous := carve.Status
 	if err := c.DB.Model(&carve).Update("status", status).Error; err != nil {
 		return fmt.Errorf("Update %v", err)
 	}
@@ -267,17 +367,25 @@ func (c *Carves) ChangeStatus(status, sessionid string) error {
 		if err := c.DB.Model(&carve).Update("completed_at", time.Now()).Error; err != nil {
 			return fmt.Errorf("Update %v", err)
 		}
+		if c.OnComplete != nil && previous != StatusCompleted {
+			c.OnComplete(carve)
+		}
 	}
 	return nil
 }
 
-// CompleteBlock to increase one block for a carve
+// CompleteBlock to refresh the completed blocks of a carve with the blocks stored
 func (c *Carves) CompleteBlock(sessionid string) error {
 	carve, err := c.GetBySession(sessionid)
 	if err != nil {
 		return fmt.Errorf("getCarveBySessionID %v", err)
 	}
-	if err := c.DB.Model(&carve).Update("completed_blocks", carve.CompletedBlocks+1).Error; err != nil {
+	// Completed blocks are counted from what is stored, so duplicated or concurrent blocks are not counted twice
+	var stored int64
+	if err := c.DB.Model(&CarvedBlock{}).Where("session_id = ?", sessionid).Distinct("block_id").Count(&stored).Error; err != nil {
+		return fmt.Errorf("Count %v", err)
+	}
+	if err := c.DB.Model(&carve).Update("completed_blocks", int(stored)).Error; err != nil {
 		return fmt.Errorf("Update %v", err)
 	}
 	return nil
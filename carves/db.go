@@ -23,10 +23,19 @@ type CarvedFile struct {
 	CompletedBlocks int
 	Status          string
 	CompletedAt     time.Time
+	InitAt          time.Time
+	RejectReason    string
 	Carver          string
 	Archived        bool
 	ArchivePath     string
 	EnvironmentID   uint
+	ScanVerdict     string
+	ScanDetail      string
+	ScannedAt       time.Time
+	ManifestStatus  string
+	ManifestDetail  string
+	Manifest        string `json:"-"`
+	ManifestAt      time.Time
 }
 
 // CarvedBlock to store each block from a carve
@@ -0,0 +1,345 @@
+package carves
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/jmpsec/osctrl/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// StatusRejected for carves rejected at init by the limits of the environment
+	StatusRejected string = "REJECTED"
+)
+
+// Limits of carves, each one is the kind of the rejections it causes
+const (
+	LimitSize         string = "size"
+	LimitNodeSessions string = "node_sessions"
+	LimitEnvSessions  string = "env_sessions"
+	LimitDailyBytes   string = "daily_bytes"
+	LimitPath         string = "path"
+)
+
+// StaleSessionAfter is the time without blocks after which a carve in progress does not count as a session
+const StaleSessionAfter = time.Hour
+
+// CarveLimitKinds to list all the limits of carves, in the order they are shown
+var CarveLimitKinds = []string{LimitSize, LimitNodeSessions, LimitEnvSessions, LimitDailyBytes, LimitPath}
+
+// CarveLimits to keep the guardrails of carves of an environment. Zero values are unlimited, so environments that
+// never set them carve as before. Denied paths are glob patterns, one per line.
+type CarveLimits struct {
+	EnvironmentID   uint      `gorm:"primaryKey;autoIncrement:false" json:"-"`
+	MaxCarveBytes   int64     `json:"max_carve_bytes"`
+	MaxNodeSessions int       `json:"max_node_sessions"`
+	MaxEnvSessions  int       `json:"max_env_sessions"`
+	DailyBytes      int64     `json:"daily_bytes"`
+	DeniedPaths     string    `json:"denied_paths"`
+	UpdatedBy       string    `json:"updated_by"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// CarveRejection to audit a carve rejected by the limits of its environment, with the user that requested it
+type CarveRejection struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	EnvironmentID uint      `gorm:"index" json:"-"`
+	Limit         string    `gorm:"column:carve_limit" json:"limit"`
+	UUID          string    `json:"uuid"`
+	QueryName     string    `json:"query_name"`
+	Path          string    `json:"path"`
+	Size          int64     `json:"size"`
+	Requester     string    `json:"requester"`
+	Detail        string    `json:"detail"`
+	CreatedAt     time.Time `gorm:"index" json:"created_at"`
+}
+
+// CarvePathOverride to allow a carve of a denied path, requested by an admin with a justification
+type CarvePathOverride struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	EnvironmentID uint      `gorm:"index" json:"-"`
+	QueryName     string    `gorm:"index" json:"query_name"`
+	Path          string    `json:"path"`
+	Justification string    `json:"justification"`
+	User          string    `json:"user"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// CarveUsage to describe the use of carves of an environment against its limits, in the current daily window
+type CarveUsage struct {
+	Limits         CarveLimits      `json:"limits"`
+	ActiveSessions int64            `json:"active_sessions"`
+	NodeSessions   int64            `json:"max_node_sessions_in_use"`
+	DailyBytes     int64            `json:"daily_bytes"`
+	WindowStart    time.Time        `json:"window_start"`
+	WindowEnd      time.Time        `json:"window_end"`
+	Rejections     map[string]int64 `json:"rejections"`
+}
+
+// CarveWindow to get the daily window of the byte budget of carves, from midnight to midnight in UTC
+func CarveWindow(now time.Time) (time.Time, time.Time) {
+	now = now.UTC()
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 0, 1)
+}
+
+// Unlimited to check if the limits of an environment were never set or were removed
+func (l CarveLimits) Unlimited() bool {
+	return l.MaxCarveBytes <= 0 && l.MaxNodeSessions <= 0 && l.MaxEnvSessions <= 0 && l.DailyBytes <= 0 && len(l.DeniedPathList()) == 0
+}
+
+// MaxCarveSize to get the maximum size of a carve for humans
+func (l CarveLimits) MaxCarveSize() string {
+	return utils.BytesReceivedConversion(int(l.MaxCarveBytes))
+}
+
+// DailySize to get the daily byte budget for humans
+func (l CarveLimits) DailySize() string {
+	return utils.BytesReceivedConversion(int(l.DailyBytes))
+}
+
+// DeniedPathList to get the denied path patterns
+func (l CarveLimits) DeniedPathList() []string {
+	var res []string
+	for _, p := range strings.Split(l.DeniedPaths, "\n") {
+		if p = strings.TrimSpace(p); p != "" {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+// Helper to normalize paths and patterns, so Windows paths match with forward slashes and osquery wildcards as globs
+func normalizeCarvePath(p string) string {
+	p = strings.ReplaceAll(strings.TrimSpace(p), "\\", "/")
+	p = strings.ReplaceAll(p, "%%", "*")
+	return strings.ReplaceAll(p, "%", "*")
+}
+
+// PathDenied to check if a path is denied, returning the pattern it matches. Paths inside a denied directory are
+// denied too, and Windows paths are compared without case.
+func (l CarveLimits) PathDenied(carvePath string) (string, bool) {
+	p := normalizeCarvePath(carvePath)
+	for _, pattern := range l.DeniedPathList() {
+		norm := normalizeCarvePath(pattern)
+		candidate := p
+		if strings.Contains(pattern, "\\") || strings.Contains(carvePath, "\\") {
+			norm, candidate = strings.ToLower(norm), strings.ToLower(candidate)
+		}
+		norm = strings.TrimSuffix(norm, "/")
+		for dir := candidate; dir != "" && dir != "." && dir != "/"; dir = path.Dir(dir) {
+			if ok, _ := path.Match(norm, dir); ok {
+				return pattern, true
+			}
+		}
+	}
+	return "", false
+}
+
+// ValidateCarveLimits to check limits are not negative and denied paths are valid patterns
+func ValidateCarveLimits(l CarveLimits) error {
+	if l.MaxCarveBytes < 0 || l.DailyBytes < 0 {
+		return fmt.Errorf("sizes can not be negative")
+	}
+	if l.MaxNodeSessions < 0 || l.MaxEnvSessions < 0 {
+		return fmt.Errorf("sessions can not be negative")
+	}
+	for _, p := range l.DeniedPathList() {
+		if _, err := path.Match(normalizeCarvePath(p), ""); err != nil {
+			return fmt.Errorf("invalid denied path %s - %v", p, err)
+		}
+	}
+	return nil
+}
+
+// CheckInit to check a carve declaring its size against the limits, with the sessions in progress of the node and
+// the environment and the bytes of the daily window. Returns the limit it exceeds and why.
+func (l CarveLimits) CheckInit(size, nodeSessions, envSessions, dailyBytes int64) (string, error) {
+	if l.MaxCarveBytes > 0 && size > l.MaxCarveBytes {
+		return LimitSize, fmt.Errorf("carve of %s exceeds the maximum of %s", utils.BytesReceivedConversion(int(size)), l.MaxCarveSize())
+	}
+	if l.MaxNodeSessions > 0 && nodeSessions >= int64(l.MaxNodeSessions) {
+		return LimitNodeSessions, fmt.Errorf("node has %d carves in progress, the maximum is %d", nodeSessions, l.MaxNodeSessions)
+	}
+	if l.MaxEnvSessions > 0 && envSessions >= int64(l.MaxEnvSessions) {
+		return LimitEnvSessions, fmt.Errorf("environment has %d carves in progress, the maximum is %d", envSessions, l.MaxEnvSessions)
+	}
+	if l.DailyBytes > 0 && dailyBytes+size > l.DailyBytes {
+		return LimitDailyBytes, fmt.Errorf("daily budget of %s exhausted, %s used", l.DailySize(), utils.BytesReceivedConversion(int(dailyBytes)))
+	}
+	return "", nil
+}
+
+// GetLimits to get the carve limits of an environment, unlimited if they were never set
+func (c *Carves) GetLimits(envid uint) (CarveLimits, error) {
+	var limits CarveLimits
+	if err := c.DB.Where("environment_id = ?", envid).Find(&limits).Error; err != nil {
+		return CarveLimits{EnvironmentID: envid}, fmt.Errorf("GetLimits %v", err)
+	}
+	limits.EnvironmentID = envid
+	return limits, nil
+}
+
+// SetLimits to set the carve limits of an environment, zero values remove each limit
+func (c *Carves) SetLimits(envid uint, limits CarveLimits, user string) (CarveLimits, error) {
+	if err := ValidateCarveLimits(limits); err != nil {
+		return limits, err
+	}
+	limits.EnvironmentID = envid
+	limits.DeniedPaths = strings.Join(limits.DeniedPathList(), "\n")
+	limits.UpdatedBy = user
+	limits.UpdatedAt = time.Now()
+	if err := c.DB.Clauses(clause.OnConflict{UpdateAll: true}).Create(&limits).Error; err != nil {
+		return limits, fmt.Errorf("SetLimits %v", err)
+	}
+	return limits, nil
+}
+
+// Helper to get the carves in progress that still receive blocks
+func (c *Carves) activeSessions(now time.Time) *gorm.DB {
+	return c.DB.Model(&CarvedFile{}).Where("status = ? AND updated_at > ?", StatusInProgress, now.Add(-StaleSessionAfter))
+}
+
+// NodeSessions to count the carves in progress of a node
+func (c *Carves) NodeSessions(uuid string, now time.Time) (int64, error) {
+	var count int64
+	if err := c.activeSessions(now).Where("uuid = ?", uuid).Count(&count).Error; err != nil {
+		return 0, fmt.Errorf("NodeSessions %v", err)
+	}
+	return count, nil
+}
+
+// EnvSessions to count the carves in progress of an environment
+func (c *Carves) EnvSessions(envid uint, now time.Time) (int64, error) {
+	var count int64
+	if err := c.activeSessions(now).Where("environment_id = ?", envid).Count(&count).Error; err != nil {
+		return 0, fmt.Errorf("EnvSessions %v", err)
+	}
+	return count, nil
+}
+
+// DailyBytes to sum the sizes declared by the carves of an environment initialized in the daily window
+func (c *Carves) DailyBytes(envid uint, now time.Time) (int64, error) {
+	start, _ := CarveWindow(now)
+	var total int64
+	err := c.DB.Model(&CarvedFile{}).Select("COALESCE(SUM(carve_size), 0)").
+		Where("environment_id = ? AND init_at >= ? AND status <> ?", envid, start, StatusRejected).Scan(&total).Error
+	if err != nil {
+		return 0, fmt.Errorf("DailyBytes %v", err)
+	}
+	return total, nil
+}
+
+// RejectCarve to mark a carve as rejected at init, with the reason shown to admins
+func (c *Carves) RejectCarve(carve CarvedFile, size int, reason string) error {
+	toUpdate := map[string]interface{}{
+		"carve_size":    size,
+		"status":        StatusRejected,
+		"reject_reason": reason,
+	}
+	if err := c.DB.Model(&carve).Updates(toUpdate).Error; err != nil {
+		return fmt.Errorf("RejectCarve %v", err)
+	}
+	return nil
+}
+
+// RecordRejection to audit a carve rejected by the limits of an environment
+func (c *Carves) RecordRejection(rejection CarveRejection) error {
+	if err := c.DB.Create(&rejection).Error; err != nil {
+		return fmt.Errorf("RecordRejection %v", err)
+	}
+	return nil
+}
+
+// Rejections to get the rejections of carves of an environment since a time, newest first
+func (c *Carves) Rejections(envid uint, since time.Time) ([]CarveRejection, error) {
+	var rejections []CarveRejection
+	if err := c.DB.Where("environment_id = ? AND created_at >= ?", envid, since).Order("created_at DESC").Find(&rejections).Error; err != nil {
+		return rejections, fmt.Errorf("Rejections %v", err)
+	}
+	return rejections, nil
+}
+
+// AddPathOverride to allow the carves of a query to collect a denied path
+func (c *Carves) AddPathOverride(override CarvePathOverride) error {
+	if strings.TrimSpace(override.Justification) == "" {
+		return fmt.Errorf("override needs a justification")
+	}
+	if err := c.DB.Create(&override).Error; err != nil {
+		return fmt.Errorf("AddPathOverride %v", err)
+	}
+	return nil
+}
+
+// PathOverridden to check if the carves of a query were allowed to collect a denied path
+func (c *Carves) PathOverridden(envid uint, queryName string) (bool, error) {
+	var count int64
+	if err := c.DB.Model(&CarvePathOverride{}).Where("environment_id = ? AND query_name = ?", envid, queryName).Count(&count).Error; err != nil {
+		return false, fmt.Errorf("PathOverridden %v", err)
+	}
+	return count > 0, nil
+}
+
+// Usage to get the use of carves of an environment against its limits, with the rejections of the daily window
+func (c *Carves) Usage(envid uint, now time.Time) (CarveUsage, error) {
+	start, end := CarveWindow(now)
+	usage := CarveUsage{WindowStart: start, WindowEnd: end, Rejections: make(map[string]int64)}
+	limits, err := c.GetLimits(envid)
+	if err != nil {
+		return usage, err
+	}
+	usage.Limits = limits
+	if usage.ActiveSessions, err = c.EnvSessions(envid, now); err != nil {
+		return usage, err
+	}
+	var perNode []int64
+	err = c.activeSessions(now).Select("COUNT(*)").Where("environment_id = ?", envid).Group("uuid").Order("COUNT(*) DESC").Limit(1).Scan(&perNode).Error
+	if err != nil {
+		return usage, fmt.Errorf("NodeSessions %v", err)
+	}
+	if len(perNode) > 0 {
+		usage.NodeSessions = perNode[0]
+	}
+	if usage.DailyBytes, err = c.DailyBytes(envid, now); err != nil {
+		return usage, err
+	}
+	rejections, err := c.Rejections(envid, start)
+	if err != nil {
+		return usage, err
+	}
+	for _, r := range rejections {
+		usage.Rejections[r.Limit]++
+	}
+	return usage, nil
+}
+
+// DailySize to get the bytes of the daily window for humans
+func (u CarveUsage) DailySize() string {
+	return utils.BytesReceivedConversion(int(u.DailyBytes))
+}
+
+// DailyPercent to get the daily bytes as percent of the budget, 0 without budget
+func (u CarveUsage) DailyPercent() int64 {
+	if u.Limits.DailyBytes <= 0 {
+		return 0
+	}
+	return u.DailyBytes * 100 / u.Limits.DailyBytes
+}
+
+// LimitsSummary to describe the carve limits of an environment, as they are recorded in the activity
+func LimitsSummary(l CarveLimits) string {
+	if l.Unlimited() {
+		return ""
+	}
+	return fmt.Sprintf("max carve: %d bytes\nmax node sessions: %d\nmax environment sessions: %d\ndaily budget: %d bytes\ndenied paths:\n%s",
+		l.MaxCarveBytes, l.MaxNodeSessions, l.MaxEnvSessions, l.DailyBytes, strings.Join(l.DeniedPathList(), "\n"))
+}
+
+// PathOverrideSummary to describe a carve of a denied path allowed by an override, as it is recorded in the activity
+func PathOverrideSummary(o CarvePathOverride, pattern string) string {
+	return fmt.Sprintf("carve %s of path %s denied by %s allowed by override: %s", o.QueryName, o.Path, pattern, o.Justification)
+}
@@ -0,0 +1,68 @@
+package carves
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCarveLimitsUnlimited(t *testing.T) {
+	assert.True(t, CarveLimits{}.Unlimited())
+	assert.True(t, CarveLimits{DeniedPaths: "\n  \n"}.Unlimited())
+	assert.False(t, CarveLimits{DailyBytes: 1024}.Unlimited())
+	assert.False(t, CarveLimits{DeniedPaths: "/etc/shadow"}.Unlimited())
+}
+
+func TestCarveLimitsCheckInit(t *testing.T) {
+	limits := CarveLimits{MaxCarveBytes: 1000, MaxNodeSessions: 1, MaxEnvSessions: 5, DailyBytes: 5000}
+	limit, err := limits.CheckInit(500, 0, 4, 4000)
+	assert.NoError(t, err)
+	assert.Equal(t, "", limit)
+	limit, err = limits.CheckInit(1001, 1, 5, 5000)
+	assert.Error(t, err)
+	assert.Equal(t, LimitSize, limit)
+	limit, _ = limits.CheckInit(500, 1, 5, 5000)
+	assert.Equal(t, LimitNodeSessions, limit)
+	limit, _ = limits.CheckInit(500, 0, 5, 5000)
+	assert.Equal(t, LimitEnvSessions, limit)
+	limit, _ = limits.CheckInit(500, 0, 0, 4600)
+	assert.Equal(t, LimitDailyBytes, limit)
+	limit, err = CarveLimits{}.CheckInit(1<<40, 100, 100, 1<<40)
+	assert.NoError(t, err)
+	assert.Equal(t, "", limit)
+}
+
+func TestCarveLimitsPathDenied(t *testing.T) {
+	limits := CarveLimits{DeniedPaths: "/etc/shadow\n/home/*/.ssh\nC:\\Windows\\System32\\config\n/var/%%/secret"}
+	pattern, denied := limits.PathDenied("/etc/shadow")
+	assert.True(t, denied)
+	assert.Equal(t, "/etc/shadow", pattern)
+	pattern, denied = limits.PathDenied("/home/alice/.ssh/id_rsa")
+	assert.True(t, denied)
+	assert.Equal(t, "/home/*/.ssh", pattern)
+	_, denied = limits.PathDenied("c:\\windows\\system32\\CONFIG\\SAM")
+	assert.True(t, denied)
+	_, denied = limits.PathDenied("/var/lib/secret")
+	assert.True(t, denied)
+	_, denied = limits.PathDenied("/etc/shadow-")
+	assert.False(t, denied)
+	_, denied = limits.PathDenied("/etc/hosts")
+	assert.False(t, denied)
+	_, denied = limits.PathDenied("/ETC/SHADOW")
+	assert.False(t, denied)
+}
+
+func TestValidateCarveLimits(t *testing.T) {
+	assert.NoError(t, ValidateCarveLimits(CarveLimits{MaxCarveBytes: 10, DeniedPaths: "/etc/*"}))
+	assert.Error(t, ValidateCarveLimits(CarveLimits{MaxCarveBytes: -1}))
+	assert.Error(t, ValidateCarveLimits(CarveLimits{MaxEnvSessions: -1}))
+	assert.Error(t, ValidateCarveLimits(CarveLimits{DeniedPaths: "/etc/[a"}))
+}
+
+func TestCarveWindow(t *testing.T) {
+	loc := time.FixedZone("UTC-5", -5*3600)
+	start, end := CarveWindow(time.Date(2021, 5, 1, 22, 30, 0, 0, loc))
+	assert.Equal(t, time.Date(2021, 5, 2, 0, 0, 0, 0, time.UTC), start)
+	assert.Equal(t, time.Date(2021, 5, 3, 0, 0, 0, 0, time.UTC), end)
+}
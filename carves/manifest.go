@@ -0,0 +1,359 @@
+package carves
+
+import (
+	"archive/tar"
+	"bufio"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jmpsec/osctrl/settings"
+)
+
+const (
+	// ManifestPending for carves with the manifest being built
+	ManifestPending string = "pending"
+	// ManifestReady for carves with a manifest, files can be extracted one by one
+	ManifestReady string = "ready"
+	// ManifestUnavailable for carves that are not tar archives, or are compressed, only the whole carve is downloaded
+	ManifestUnavailable string = "unavailable"
+	// ManifestError for carves that could not be read to build the manifest
+	ManifestError string = "error"
+)
+
+const (
+	// EntryFile for regular files in the manifest of a carve, the only entries that can be extracted
+	EntryFile string = "file"
+	// EntryDir for directories in the manifest of a carve
+	EntryDir string = "dir"
+	// EntryLink for symbolic and hard links in the manifest of a carve
+	EntryLink string = "link"
+	// EntryOther for any other entry in the manifest of a carve
+	EntryOther string = "other"
+)
+
+// MaxManifestEntries is how many entries are kept in the manifest of a carve, the rest are not indexed
+const MaxManifestEntries = 50000
+
+// ManifestEntry to describe one entry of a carved tar archive, Offset is where the content of files starts
+type ManifestEntry struct {
+	Path    string    `json:"path"`
+	Type    string    `json:"type"`
+	Size    int64     `json:"size"`
+	Mode    string    `json:"mode"`
+	ModTime time.Time `json:"mtime"`
+	SHA256  string    `json:"sha256,omitempty"`
+	Link    string    `json:"link,omitempty"`
+	Offset  int64     `json:"offset"`
+}
+
+// CarveManifest to return the manifest of a carve with its status
+type CarveManifest struct {
+	CarveID   string          `json:"carve_id"`
+	SessionID string          `json:"session_id"`
+	Status    string          `json:"status"`
+	Detail    string          `json:"detail"`
+	BuiltAt   time.Time       `json:"built_at"`
+	Entries   []ManifestEntry `json:"entries"`
+}
+
+// ManifestNode to display the manifest of a carve as a tree, directories without entry are implicit
+type ManifestNode struct {
+	Name     string
+	Path     string
+	Depth    int
+	Dir      bool
+	Implicit bool
+	Entry    ManifestEntry
+}
+
+// Reader that counts the bytes read, to know the offset of each file in the archive
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// ManifestPath to normalize the path of an entry, always absolute and with forward slashes
+func ManifestPath(p string) string {
+	return path.Clean("/" + strings.ReplaceAll(p, "\\", "/"))
+}
+
+// ReadManifest to read a tar archive and build its manifest, hashing the content of each file.
+// It returns true when the archive had more entries than the manifest keeps.
+func ReadManifest(r io.Reader) ([]ManifestEntry, bool, error) {
+	counter := &countingReader{r: r}
+	tr := tar.NewReader(counter)
+	entries := []ManifestEntry{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return entries, false, nil
+		}
+		if err != nil {
+			return entries, false, fmt.Errorf("invalid tar archive - %v", err)
+		}
+		if len(entries) >= MaxManifestEntries {
+			return entries, true, nil
+		}
+		e := ManifestEntry{
+			Path:    ManifestPath(hdr.Name),
+			Mode:    os.FileMode(hdr.Mode).Perm().String(),
+			ModTime: hdr.ModTime.UTC(),
+			Offset:  -1,
+		}
+		switch hdr.Typeflag {
+		case tar.TypeReg, '\x00':
+			e.Type = EntryFile
+			e.Size = hdr.Size
+			// The reader is at the start of the content once the header is read
+			e.Offset = counter.n
+			h := sha256.New()
+			if _, err := io.Copy(h, tr); err != nil {
+				return entries, false, fmt.Errorf("error reading %s - %v", e.Path, err)
+			}
+			e.SHA256 = hex.EncodeToString(h.Sum(nil))
+		case tar.TypeDir:
+			e.Type = EntryDir
+		case tar.TypeSymlink, tar.TypeLink:
+			e.Type = EntryLink
+			e.Link = hdr.Linkname
+		default:
+			e.Type = EntryOther
+			e.Size = hdr.Size
+		}
+		entries = append(entries, e)
+	}
+}
+
+// FindEntry to find the entry of a path in the manifest of a carve
+func FindEntry(entries []ManifestEntry, p string) (ManifestEntry, bool) {
+	p = ManifestPath(p)
+	for _, e := range entries {
+		if e.Path == p {
+			return e, true
+		}
+	}
+	return ManifestEntry{}, false
+}
+
+// ManifestTree to sort the entries of a manifest as a tree, adding the directories that have no entry
+func ManifestTree(entries []ManifestEntry) []ManifestNode {
+	sorted := make([]ManifestEntry, len(entries))
+	copy(sorted, entries)
+	// Sorting by segments keeps the children of each directory right after it
+	key := func(p string) string {
+		return strings.ReplaceAll(p, "/", "\x00")
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return key(sorted[i].Path) < key(sorted[j].Path)
+	})
+	var tree []ManifestNode
+	dirs := make(map[string]bool)
+	for _, e := range sorted {
+		segments := strings.Split(strings.TrimPrefix(e.Path, "/"), "/")
+		for i := 1; i < len(segments); i++ {
+			dir := "/" + strings.Join(segments[:i], "/")
+			if dirs[dir] {
+				continue
+			}
+			dirs[dir] = true
+			tree = append(tree, ManifestNode{Name: segments[i-1], Path: dir, Depth: i - 1, Dir: true, Implicit: true})
+		}
+		if e.Type == EntryDir {
+			if dirs[e.Path] {
+				continue
+			}
+			dirs[e.Path] = true
+		}
+		tree = append(tree, ManifestNode{
+			Name:  segments[len(segments)-1],
+			Path:  e.Path,
+			Depth: len(segments) - 1,
+			Dir:   e.Type == EntryDir,
+			Entry: e,
+		})
+	}
+	return tree
+}
+
+// Entries to get the entries of the manifest of a carve, empty if it has no manifest
+func (carve CarvedFile) Entries() ([]ManifestEntry, error) {
+	var entries []ManifestEntry
+	if carve.Manifest == "" {
+		return entries, nil
+	}
+	if err := json.Unmarshal([]byte(carve.Manifest), &entries); err != nil {
+		return entries, fmt.Errorf("invalid manifest %v", err)
+	}
+	return entries, nil
+}
+
+// ManifestOf to get the manifest of a carve with its status
+func ManifestOf(carve CarvedFile) (CarveManifest, error) {
+	entries, err := carve.Entries()
+	return CarveManifest{
+		CarveID:   carve.CarveID,
+		SessionID: carve.SessionID,
+		Status:    carve.ManifestStatus,
+		Detail:    carve.ManifestDetail,
+		BuiltAt:   carve.ManifestAt,
+		Entries:   entries,
+	}, err
+}
+
+// ExtractSummary to describe the extraction of one file of a carve, for the activity of environments
+func ExtractSummary(carve CarvedFile, entry ManifestEntry) string {
+	return fmt.Sprintf("file %s (%d bytes, sha256 %s) extracted from carve %s (%s)", entry.Path, entry.Size, entry.SHA256, carve.CarveID, carve.Path)
+}
+
+// Helper to get the size of the decoded data of a block
+func decodedSize(data string) int64 {
+	n := int64(len(data)) / 4 * 3
+	if strings.HasSuffix(data, "==") {
+		n -= 2
+	} else if strings.HasSuffix(data, "=") {
+		n--
+	}
+	return n
+}
+
+// ContentRange to read part of the reassembled content of a carve, only the blocks with that part are decoded.
+// For the s3 carver it uses a ranged read of the archived carve.
+func (c *Carves) ContentRange(carve CarvedFile, offset, size int64) (io.ReadCloser, error) {
+	if size == 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	if carve.Carver == settings.CarverS3 {
+		if c.S3 == nil {
+			return nil, fmt.Errorf("S3 carver not initialized")
+		}
+		return c.S3.Reader(carve, offset, size)
+	}
+	blocks, err := c.GetBlocks(carve.SessionID)
+	if err != nil {
+		return nil, fmt.Errorf("GetBlocks %v", err)
+	}
+	var readers []io.Reader
+	var pos, skip int64
+	for _, b := range blocks {
+		n := decodedSize(b.Data)
+		if pos+n <= offset {
+			pos += n
+			continue
+		}
+		if pos >= offset+size {
+			break
+		}
+		if len(readers) == 0 {
+			skip = offset - pos
+		}
+		readers = append(readers, base64.NewDecoder(base64.StdEncoding, strings.NewReader(b.Data)))
+		pos += n
+	}
+	if len(readers) == 0 {
+		return nil, fmt.Errorf("offset %d is beyond the carve", offset)
+	}
+	r := io.MultiReader(readers...)
+	if _, err := io.CopyN(io.Discard, r, skip); err != nil {
+		return nil, fmt.Errorf("error seeking carve - %v", err)
+	}
+	return io.NopCloser(io.LimitReader(r, size)), nil
+}
+
+// SetManifest to store the manifest of a carve and its status
+func (c *Carves) SetManifest(sessionid, status, detail string, entries []ManifestEntry) error {
+	carve, err := c.GetBySession(sessionid)
+	if err != nil {
+		return fmt.Errorf("getCarveBySessionID %v", err)
+	}
+	toUpdate := map[string]interface{}{
+		"manifest_status": status,
+		"manifest_detail": detail,
+		"manifest":        "",
+	}
+	if entries != nil {
+		data, err := json.Marshal(entries)
+		if err != nil {
+			return fmt.Errorf("error serializing manifest %v", err)
+		}
+		toUpdate["manifest"] = string(data)
+	}
+	if status != ManifestPending {
+		toUpdate["manifest_at"] = time.Now()
+	}
+	if err := c.DB.Model(&carve).Updates(toUpdate).Error; err != nil {
+		return fmt.Errorf("Updates %v", err)
+	}
+	return nil
+}
+
+// BuildManifest to build and store the manifest of a completed carve. Carves that are compressed or are not tar
+// archives are stored as unavailable, and can only be downloaded whole.
+func (c *Carves) BuildManifest(sessionid string) (string, error) {
+	carve, err := c.GetBySession(sessionid)
+	if err != nil {
+		return ManifestError, fmt.Errorf("getCarveBySessionID %v", err)
+	}
+	var content io.Reader
+	if carve.Carver == settings.CarverS3 {
+		var rc io.ReadCloser
+		rc, err = c.ContentRange(carve, 0, -1)
+		if err == nil {
+			defer rc.Close()
+			content = rc
+		}
+	} else {
+		content, err = c.Content(carve)
+	}
+	if err != nil {
+		return ManifestError, c.SetManifest(sessionid, ManifestError, err.Error(), nil)
+	}
+	br := bufio.NewReader(content)
+	if head, _ := br.Peek(len(CompressionHeader)); len(head) == len(CompressionHeader) && CheckCompressionRaw(head) {
+		return ManifestUnavailable, c.SetManifest(sessionid, ManifestUnavailable, "compressed carve", nil)
+	}
+	entries, truncated, err := ReadManifest(br)
+	if err != nil {
+		return ManifestUnavailable, c.SetManifest(sessionid, ManifestUnavailable, err.Error(), nil)
+	}
+	detail := fmt.Sprintf("%d entries", len(entries))
+	if truncated {
+		detail = fmt.Sprintf("first %d entries, the rest are not indexed", len(entries))
+	}
+	return ManifestReady, c.SetManifest(sessionid, ManifestReady, detail, entries)
+}
+
+// StartManifest to set a completed carve as pending and build its manifest in the background
+func (c *Carves) StartManifest(sessionid string) error {
+	carve, err := c.GetBySession(sessionid)
+	if err != nil {
+		return fmt.Errorf("getCarveBySessionID %v", err)
+	}
+	if carve.Status != StatusCompleted {
+		return fmt.Errorf("carve %s is not completed", carve.CarveID)
+	}
+	if err := c.SetManifest(sessionid, ManifestPending, "", nil); err != nil {
+		return err
+	}
+	go func() {
+		if _, err := c.BuildManifest(sessionid); err != nil {
+			log.Printf("error building manifest of carve %s - %v", carve.CarveID, err)
+		}
+	}()
+	return nil
+}
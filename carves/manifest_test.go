@@ -0,0 +1,115 @@
+package carves
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testArchive(t *testing.T) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	mtime := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+	files := []struct {
+		name     string
+		typeflag byte
+		content  string
+		link     string
+	}{
+		{"etc/", tar.TypeDir, "", ""},
+		{"etc/hosts", tar.TypeReg, "127.0.0.1 localhost\n", ""},
+		{"etc/ssh/sshd_config", tar.TypeReg, "PermitRootLogin no\n", ""},
+		{"etc/localtime", tar.TypeSymlink, "", "/usr/share/zoneinfo/UTC"},
+		{"etc-backup/empty", tar.TypeReg, "", ""},
+	}
+	for _, f := range files {
+		hdr := &tar.Header{Name: f.name, Typeflag: f.typeflag, Mode: 0644, ModTime: mtime, Size: int64(len(f.content)), Linkname: f.link}
+		assert.NoError(t, tw.WriteHeader(hdr))
+		if f.content != "" {
+			_, err := tw.Write([]byte(f.content))
+			assert.NoError(t, err)
+		}
+	}
+	assert.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+func TestReadManifest(t *testing.T) {
+	archive := testArchive(t)
+	entries, truncated, err := ReadManifest(bytes.NewReader(archive))
+	assert.NoError(t, err)
+	assert.False(t, truncated)
+	assert.Equal(t, 5, len(entries))
+	hosts, ok := FindEntry(entries, "/etc/hosts")
+	assert.True(t, ok)
+	assert.Equal(t, EntryFile, hosts.Type)
+	assert.Equal(t, int64(20), hosts.Size)
+	assert.Equal(t, "-rw-r--r--", hosts.Mode)
+	// Offsets point to the content of the files in the archive
+	content := archive[hosts.Offset : hosts.Offset+hosts.Size]
+	assert.Equal(t, "127.0.0.1 localhost\n", string(content))
+	sum := sha256.Sum256(content)
+	assert.Equal(t, hex.EncodeToString(sum[:]), hosts.SHA256)
+	link, ok := FindEntry(entries, "etc/localtime")
+	assert.True(t, ok)
+	assert.Equal(t, EntryLink, link.Type)
+	assert.Equal(t, int64(-1), link.Offset)
+	_, ok = FindEntry(entries, "/etc/passwd")
+	assert.False(t, ok)
+}
+
+func TestReadManifestInvalid(t *testing.T) {
+	_, _, err := ReadManifest(bytes.NewReader([]byte("this is not a tar archive")))
+	assert.Error(t, err)
+	entries, _, err := ReadManifest(bytes.NewReader(nil))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(entries))
+}
+
+func TestManifestPath(t *testing.T) {
+	assert.Equal(t, "/etc/hosts", ManifestPath("etc/hosts"))
+	assert.Equal(t, "/etc/hosts", ManifestPath("./etc/hosts"))
+	assert.Equal(t, "/C:/Windows/win.ini", ManifestPath("C:\\Windows\\win.ini"))
+	assert.Equal(t, "/etc", ManifestPath("/etc/"))
+}
+
+func TestManifestTree(t *testing.T) {
+	entries, _, err := ReadManifest(bytes.NewReader(testArchive(t)))
+	assert.NoError(t, err)
+	tree := ManifestTree(entries)
+	var paths []string
+	for _, n := range tree {
+		paths = append(paths, n.Path)
+	}
+	assert.Equal(t, []string{"/etc", "/etc/hosts", "/etc/localtime", "/etc/ssh", "/etc/ssh/sshd_config", "/etc-backup", "/etc-backup/empty"}, paths)
+	assert.False(t, tree[0].Implicit)
+	assert.True(t, tree[3].Implicit)
+	assert.True(t, tree[3].Dir)
+	assert.Equal(t, 2, tree[4].Depth)
+	assert.Equal(t, "sshd_config", tree[4].Name)
+}
+
+func TestDecodedSize(t *testing.T) {
+	for _, data := range []string{"", "a", "ab", "abc", "abcd", "abcdefgh"} {
+		encoded := base64.StdEncoding.EncodeToString([]byte(data))
+		assert.Equal(t, int64(len(data)), decodedSize(encoded), data)
+	}
+}
+
+func TestCarvedFileEntries(t *testing.T) {
+	entries, err := CarvedFile{}.Entries()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(entries))
+	_, err = CarvedFile{Manifest: "{"}.Entries()
+	assert.Error(t, err)
+	m, err := ManifestOf(CarvedFile{CarveID: "carve", ManifestStatus: ManifestReady, Manifest: `[{"path":"/etc/hosts","type":"file","size":20}]`})
+	assert.NoError(t, err)
+	assert.Equal(t, "carve", m.CarveID)
+	assert.Equal(t, 1, len(m.Entries))
+}
@@ -0,0 +1,213 @@
+package carves
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+)
+
+const (
+	// DefaultBlockQueueSize is the default maximum number of pending blocks waiting to be stored
+	DefaultBlockQueueSize = 1000
+	// DefaultBlockWorkersDB is the default number of concurrent block writes to the database
+	DefaultBlockWorkersDB = 4
+	// DefaultBlockWorkersS3 is the default number of concurrent block uploads to S3
+	DefaultBlockWorkersS3 = 16
+	// DefaultBlockRetries is the default number of attempts to store one block
+	DefaultBlockRetries = 3
+	// DefaultBlockShutdown is the default time to store pending blocks on shutdown
+	DefaultBlockShutdown = 30 * time.Second
+	// DefaultBlockRetryAfter is the time nodes are told to wait before sending a rejected block again
+	DefaultBlockRetryAfter = 5 * time.Second
+)
+
+// ErrBlockQueueFull is returned when a block is rejected because the queue is full
+var ErrBlockQueueFull = fmt.Errorf("carve block queue is full")
+
+// ErrBlockQueueClosed is returned when a block is rejected because the queue is shutting down
+var ErrBlockQueueClosed = fmt.Errorf("carve block queue is closed")
+
+// BlockQueueConfig to hold all the configuration values for the carve block queue
+type BlockQueueConfig struct {
+	// Maximum number of pending blocks
+	Size int
+	// Concurrent writes for each carver, so one backend can not exhaust connections or sockets
+	Workers map[string]int
+	// Attempts to store one block before giving up
+	Retries int
+	// Wait between attempts, multiplied by the attempt number
+	RetryWait time.Duration
+}
+
+// WorkersFor to get the number of concurrent writes for a carver
+func (cfg BlockQueueConfig) WorkersFor(carver string) int {
+	if w, ok := cfg.Workers[carver]; ok && w > 0 {
+		return w
+	}
+	if carver == settings.CarverS3 {
+		return DefaultBlockWorkersS3
+	}
+	return DefaultBlockWorkersDB
+}
+
+// BlockQueueStats to expose the state of the carve block queue as metrics
+type BlockQueueStats struct {
+	Depth     int
+	Rejected  int
+	Processed int
+	Failed    int
+	// Average and maximum time to store one block, in milliseconds
+	Latency    int
+	MaxLatency int
+}
+
+// BlockJob to hold one received block waiting to be stored
+type BlockJob struct {
+	Request     types.CarveBlockRequest
+	Environment string
+	UUID        string
+	EnvID       uint
+}
+
+// BlockQueue is a bounded in-memory queue of carve blocks, stored by a pool of
+// workers sized for the carver. Blocks are never dropped, when the queue is full
+// they are rejected so the node sends them again
+type BlockQueue struct {
+	Config    BlockQueueConfig
+	Carver    string
+	mux       sync.Mutex
+	jobs      chan BlockJob
+	closed    bool
+	rejected  int
+	processed int
+	failed    int
+	latency   time.Duration
+	max       time.Duration
+	workers   sync.WaitGroup
+	process   func(BlockJob) error
+}
+
+// CreateBlockQueue to initialize the carve block queue, workers are started with Start
+func CreateBlockQueue(cfg BlockQueueConfig, carver string, process func(BlockJob) error) *BlockQueue {
+	if cfg.Size <= 0 {
+		cfg.Size = DefaultBlockQueueSize
+	}
+	if cfg.Retries <= 0 {
+		cfg.Retries = DefaultBlockRetries
+	}
+	if cfg.RetryWait <= 0 {
+		cfg.RetryWait = time.Second
+	}
+	return &BlockQueue{
+		Config:  cfg,
+		Carver:  carver,
+		jobs:    make(chan BlockJob, cfg.Size),
+		process: process,
+	}
+}
+
+// Start to launch the workers that store the blocks
+func (q *BlockQueue) Start() {
+	for i := 0; i < q.Config.WorkersFor(q.Carver); i++ {
+		q.workers.Add(1)
+		go q.worker()
+	}
+}
+
+// Enqueue to add one block to the queue, it never waits for room
+func (q *BlockQueue) Enqueue(job BlockJob) error {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	if q.closed {
+		q.rejected++
+		return ErrBlockQueueClosed
+	}
+	select {
+	case q.jobs <- job:
+		return nil
+	default:
+		q.rejected++
+		return ErrBlockQueueFull
+	}
+}
+
+// Worker to store blocks until the queue is closed and empty
+func (q *BlockQueue) worker() {
+	defer q.workers.Done()
+	for job := range q.jobs {
+		start := time.Now()
+		var err error
+		for i := 1; i <= q.Config.Retries; i++ {
+			if err = q.process(job); err == nil {
+				break
+			}
+			if i < q.Config.Retries {
+				time.Sleep(time.Duration(i) * q.Config.RetryWait)
+			}
+		}
+		elapsed := time.Since(start)
+		q.mux.Lock()
+		if err != nil {
+			q.failed++
+		} else {
+			q.processed++
+		}
+		q.latency += elapsed
+		if elapsed > q.max {
+			q.max = elapsed
+		}
+		q.mux.Unlock()
+		if err != nil {
+			log.Printf("error storing block %d of carve %s after %d attempts %v", job.Request.BlockID, job.Request.SessionID, q.Config.Retries, err)
+		}
+	}
+}
+
+// Stats to retrieve the current state of the queue and reset the counters
+func (q *BlockQueue) Stats() BlockQueueStats {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	stats := BlockQueueStats{
+		Depth:      len(q.jobs),
+		Rejected:   q.rejected,
+		Processed:  q.processed,
+		Failed:     q.failed,
+		MaxLatency: int(q.max.Milliseconds()),
+	}
+	if writes := q.processed + q.failed; writes > 0 {
+		stats.Latency = int(q.latency.Milliseconds()) / writes
+	}
+	q.rejected = 0
+	q.processed = 0
+	q.failed = 0
+	q.latency = 0
+	q.max = 0
+	return stats
+}
+
+// Shutdown to stop accepting blocks and wait for the workers to store the pending ones
+func (q *BlockQueue) Shutdown(timeout time.Duration) error {
+	q.mux.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.jobs)
+	}
+	q.mux.Unlock()
+	done := make(chan struct{})
+	go func() {
+		q.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+		lost := len(q.jobs)
+		log.Printf("carve block queue drain timed out with %d pending blocks", lost)
+		return fmt.Errorf("timeout draining carve block queue, %d blocks pending", lost)
+	}
+}
@@ -0,0 +1,76 @@
+package carves
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlockQueueWorkersFor(t *testing.T) {
+	cfg := BlockQueueConfig{Workers: map[string]int{settings.CarverS3: 2}}
+	assert.Equal(t, 2, cfg.WorkersFor(settings.CarverS3))
+	assert.Equal(t, DefaultBlockWorkersDB, cfg.WorkersFor(settings.CarverDB))
+	assert.Equal(t, DefaultBlockWorkersS3, BlockQueueConfig{}.WorkersFor(settings.CarverS3))
+}
+
+func TestBlockQueueRejectsWhenFull(t *testing.T) {
+	release := make(chan struct{})
+	q := CreateBlockQueue(BlockQueueConfig{Size: 1, Workers: map[string]int{settings.CarverDB: 1}}, settings.CarverDB, func(job BlockJob) error {
+		<-release
+		return nil
+	})
+	// Without workers nothing is taken from the queue
+	assert.NoError(t, q.Enqueue(BlockJob{Request: types.CarveBlockRequest{BlockID: 0}}))
+	assert.Equal(t, ErrBlockQueueFull, q.Enqueue(BlockJob{Request: types.CarveBlockRequest{BlockID: 1}}))
+	stats := q.Stats()
+	assert.Equal(t, 1, stats.Depth)
+	assert.Equal(t, 1, stats.Rejected)
+	q.Start()
+	close(release)
+	assert.NoError(t, q.Shutdown(time.Second))
+	assert.Equal(t, ErrBlockQueueClosed, q.Enqueue(BlockJob{}))
+	stats = q.Stats()
+	assert.Equal(t, 0, stats.Depth)
+	assert.Equal(t, 1, stats.Processed)
+}
+
+func TestBlockQueueDrainsOnShutdown(t *testing.T) {
+	var mux sync.Mutex
+	stored := make(map[int]bool)
+	q := CreateBlockQueue(BlockQueueConfig{Size: 10}, settings.CarverDB, func(job BlockJob) error {
+		mux.Lock()
+		defer mux.Unlock()
+		stored[job.Request.BlockID] = true
+		return nil
+	})
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, q.Enqueue(BlockJob{Request: types.CarveBlockRequest{BlockID: i}}))
+	}
+	q.Start()
+	assert.NoError(t, q.Shutdown(time.Second))
+	assert.Len(t, stored, 10)
+	assert.Equal(t, 10, q.Stats().Processed)
+}
+
+func TestBlockQueueRetries(t *testing.T) {
+	attempts := 0
+	q := CreateBlockQueue(BlockQueueConfig{Size: 1, Retries: 3, RetryWait: time.Millisecond}, settings.CarverDB, func(job BlockJob) error {
+		attempts++
+		if attempts < 3 {
+			return fmt.Errorf("transient")
+		}
+		return nil
+	})
+	q.Start()
+	assert.NoError(t, q.Enqueue(BlockJob{}))
+	assert.NoError(t, q.Shutdown(time.Second))
+	stats := q.Stats()
+	assert.Equal(t, 3, attempts)
+	assert.Equal(t, 1, stats.Processed)
+	assert.Equal(t, 0, stats.Failed)
+}
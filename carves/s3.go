@@ -217,6 +217,31 @@ func (carveS3 *CarverS3) Download(carve CarvedFile) (io.WriterAt, error) {
 	return fileReader, nil
 }
 
+// Reader - Function to read part of an archived carve from s3 with a ranged read, a negative size reads to the end
+func (carveS3 *CarverS3) Reader(carve CarvedFile, offset, size int64) (io.ReadCloser, error) {
+	ctx := context.Background()
+	if !carve.Archived {
+		return nil, fmt.Errorf("carve %s is not archived", carve.CarveID)
+	}
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(carveS3.S3Config.Bucket),
+		Key:    aws.String(S3URLtoKey(carve.ArchivePath, carveS3.S3Config.Bucket)),
+	}
+	if size > 0 {
+		input.Range = aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+size-1))
+	} else if offset > 0 {
+		input.Range = aws.String(fmt.Sprintf("bytes=%d-", offset))
+	}
+	if carveS3.Debug {
+		log.Printf("DebugService: Reading %s from S3 at %d", carve.ArchivePath, offset)
+	}
+	output, err := carveS3.Client.GetObject(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("GetObject - %s", err)
+	}
+	return output.Body, nil
+}
+
 // GetDownloadLink - Function to generate a pre-signed link to download directly from s3
 func (carveS3 *CarverS3) GetDownloadLink(carve CarvedFile) (string, error) {
 	ctx := context.Background()
@@ -0,0 +1,334 @@
+package carves
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmpsec/osctrl/settings"
+)
+
+const (
+	// ScanPending for carves waiting for the verdict of the scanner
+	ScanPending string = "pending"
+	// ScanClean for carves that the scanner found clean
+	ScanClean string = "clean"
+	// ScanSuspicious for carves that the scanner found suspicious
+	ScanSuspicious string = "suspicious"
+	// ScanMalicious for carves that the scanner found malicious
+	ScanMalicious string = "malicious"
+	// ScanError for carves that could not be scanned
+	ScanError string = "error"
+)
+
+const (
+	// ScanPolicyOff to allow downloads of carves regardless of the verdict
+	ScanPolicyOff string = "off"
+	// ScanPolicyEnforce to block malicious and not scanned carves, and to warn about suspicious carves
+	ScanPolicyEnforce string = "enforce"
+)
+
+const (
+	// DownloadAllow for carves that can be downloaded
+	DownloadAllow string = "allow"
+	// DownloadWarn for carves that can be downloaded after a warning
+	DownloadWarn string = "warn"
+	// DownloadBlock for carves that can only be downloaded with an override
+	DownloadBlock string = "block"
+)
+
+// DefaultScanTimeout is the default time the scanner has to return a verdict
+const DefaultScanTimeout = 5 * time.Minute
+
+// Maximum size of the response of the scanner
+const maxScanResponse = 1024 * 1024
+
+// ScanPolicies to list all the valid scan policies for environments
+var ScanPolicies = []string{ScanPolicyOff, ScanPolicyEnforce}
+
+// ScanRequest to describe the carve sent to the scanner, URL is only set when the content is not sent
+type ScanRequest struct {
+	CarveID     string `json:"carve_id"`
+	SessionID   string `json:"session_id"`
+	UUID        string `json:"uuid"`
+	Environment string `json:"environment"`
+	Path        string `json:"path"`
+	Size        int    `json:"size"`
+	URL         string `json:"url,omitempty"`
+}
+
+// ScanResult to hold the verdict returned by the scanner
+type ScanResult struct {
+	Verdict string `json:"verdict"`
+	Detail  string `json:"detail"`
+}
+
+// Scanner to post-process completed carves, using a webhook (http or https URL) or a local command.
+// The webhook receives the content of the carve with the carve details in headers, or the request as JSON
+// with a download URL. The command receives the content in stdin and the details in environment variables.
+// Both return the verdict as JSON.
+type Scanner struct {
+	Target  string
+	Timeout time.Duration
+	Client  *http.Client
+}
+
+// NewScanner to initialize a scanner, with the timeout in seconds
+func NewScanner(target string, timeout int64) *Scanner {
+	s := &Scanner{
+		Target:  strings.TrimSpace(target),
+		Timeout: DefaultScanTimeout,
+	}
+	if timeout > 0 {
+		s.Timeout = time.Duration(timeout) * time.Second
+	}
+	s.Client = &http.Client{Timeout: s.Timeout}
+	return s
+}
+
+// ScannerFromSettings to initialize the configured scanner, nil if there is none
+func ScannerFromSettings(mgr *settings.Settings) *Scanner {
+	target := mgr.CarveScanner()
+	if target == "" {
+		return nil
+	}
+	return NewScanner(target, mgr.CarveScanTimeout())
+}
+
+// Webhook to check if the scanner is a webhook
+func (s *Scanner) Webhook() bool {
+	return strings.HasPrefix(s.Target, "http://") || strings.HasPrefix(s.Target, "https://")
+}
+
+// Scan to get the verdict of one carve, from its content or from the URL in the request if content is nil
+func (s *Scanner) Scan(ctx context.Context, req ScanRequest, content io.Reader) (ScanResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.Timeout)
+	defer cancel()
+	var out []byte
+	var err error
+	if s.Webhook() {
+		out, err = s.webhook(ctx, req, content)
+	} else {
+		out, err = s.command(ctx, req, content)
+	}
+	if err != nil {
+		return ScanResult{}, err
+	}
+	return ParseScanResult(out)
+}
+
+// Helper to send a carve to the webhook of the scanner
+func (s *Scanner) webhook(ctx context.Context, req ScanRequest, content io.Reader) ([]byte, error) {
+	var body io.Reader
+	contentType := "application/octet-stream"
+	if content != nil {
+		body = content
+	} else {
+		data, err := json.Marshal(req)
+		if err != nil {
+			return nil, fmt.Errorf("error serializing scan request %v", err)
+		}
+		body = bytes.NewReader(data)
+		contentType = "application/json"
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, s.Target, body)
+	if err != nil {
+		return nil, fmt.Errorf("error preparing scan request %v", err)
+	}
+	r.Header.Set("Content-Type", contentType)
+	r.Header.Set("X-Osctrl-Carve-Id", req.CarveID)
+	r.Header.Set("X-Osctrl-Session-Id", req.SessionID)
+	r.Header.Set("X-Osctrl-Node", req.UUID)
+	r.Header.Set("X-Osctrl-Environment", req.Environment)
+	r.Header.Set("X-Osctrl-Path", req.Path)
+	resp, err := s.Client.Do(r)
+	if err != nil {
+		return nil, fmt.Errorf("error sending scan request %v", err)
+	}
+	defer resp.Body.Close()
+	out, err := io.ReadAll(io.LimitReader(resp.Body, maxScanResponse))
+	if err != nil {
+		return nil, fmt.Errorf("error reading scan response %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("scanner returned HTTP %d", resp.StatusCode)
+	}
+	return out, nil
+}
+
+// Helper to send a carve to the local command of the scanner
+func (s *Scanner) command(ctx context.Context, req ScanRequest, content io.Reader) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, s.Target)
+	cmd.Env = append(os.Environ(),
+		"OSCTRL_CARVE_ID="+req.CarveID,
+		"OSCTRL_SESSION_ID="+req.SessionID,
+		"OSCTRL_NODE="+req.UUID,
+		"OSCTRL_ENVIRONMENT="+req.Environment,
+		"OSCTRL_PATH="+req.Path,
+		"OSCTRL_SIZE="+strconv.Itoa(req.Size),
+		"OSCTRL_URL="+req.URL,
+	)
+	if content != nil {
+		cmd.Stdin = content
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("error running scanner %v - %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// ParseScanResult to parse and validate the verdict returned by the scanner
+func ParseScanResult(data []byte) (ScanResult, error) {
+	var res ScanResult
+	if err := json.Unmarshal(data, &res); err != nil {
+		return res, fmt.Errorf("invalid scan response %v", err)
+	}
+	res.Verdict = strings.ToLower(strings.TrimSpace(res.Verdict))
+	switch res.Verdict {
+	case ScanClean, ScanSuspicious, ScanMalicious:
+		return res, nil
+	}
+	return res, fmt.Errorf("invalid verdict %q", res.Verdict)
+}
+
+// ValidScanPolicy to check if a scan policy for environments is valid
+func ValidScanPolicy(policy string) bool {
+	for _, p := range ScanPolicies {
+		if p == policy {
+			return true
+		}
+	}
+	return false
+}
+
+// DownloadDecision to decide if a carve can be downloaded, given the scan policy of the environment and its verdict
+func DownloadDecision(policy, verdict string) string {
+	if policy != ScanPolicyEnforce {
+		return DownloadAllow
+	}
+	switch verdict {
+	case ScanClean:
+		return DownloadAllow
+	case ScanSuspicious:
+		return DownloadWarn
+	}
+	// Malicious, pending, failed and not scanned carves
+	return DownloadBlock
+}
+
+// Content to read the reassembled content of a carve stored in blocks, for the db and local carvers
+func (c *Carves) Content(carve CarvedFile) (io.Reader, error) {
+	if carve.Carver == settings.CarverS3 {
+		return nil, fmt.Errorf("content of s3 carves is not stored in blocks")
+	}
+	blocks, err := c.GetBlocks(carve.SessionID)
+	if err != nil {
+		return nil, fmt.Errorf("GetBlocks %v", err)
+	}
+	if len(blocks) == 0 {
+		return nil, fmt.Errorf("no blocks for %s", carve.SessionID)
+	}
+	readers := make([]io.Reader, 0, len(blocks))
+	for _, b := range blocks {
+		readers = append(readers, base64.NewDecoder(base64.StdEncoding, strings.NewReader(b.Data)))
+	}
+	return io.MultiReader(readers...), nil
+}
+
+// SetVerdict to store the verdict of the scanner for one carve
+func (c *Carves) SetVerdict(sessionid, verdict, detail string) error {
+	carve, err := c.GetBySession(sessionid)
+	if err != nil {
+		return fmt.Errorf("getCarveBySessionID %v", err)
+	}
+	toUpdate := map[string]interface{}{
+		"scan_verdict": verdict,
+		"scan_detail":  detail,
+	}
+	if verdict != ScanPending {
+		toUpdate["scanned_at"] = time.Now()
+	}
+	if err := c.DB.Model(&carve).Updates(toUpdate).Error; err != nil {
+		return fmt.Errorf("Updates %v", err)
+	}
+	return nil
+}
+
+// Scan to scan one completed carve and store the verdict, failures are stored as the error verdict
+func (c *Carves) Scan(scanner *Scanner, sessionid string) (ScanResult, error) {
+	carve, err := c.GetBySession(sessionid)
+	if err != nil {
+		return ScanResult{}, fmt.Errorf("getCarveBySessionID %v", err)
+	}
+	req := ScanRequest{
+		CarveID:     carve.CarveID,
+		SessionID:   carve.SessionID,
+		UUID:        carve.UUID,
+		Environment: carve.Environment,
+		Path:        carve.Path,
+		Size:        carve.CarveSize,
+	}
+	var content io.Reader
+	if carve.Carver == settings.CarverS3 {
+		if c.S3 == nil {
+			err = fmt.Errorf("S3 carver not initialized")
+		} else {
+			req.URL, err = c.S3.GetDownloadLink(carve)
+		}
+	} else {
+		content, err = c.Content(carve)
+	}
+	var res ScanResult
+	if err == nil {
+		res, err = scanner.Scan(context.Background(), req, content)
+	}
+	if err != nil {
+		res = ScanResult{Verdict: ScanError, Detail: err.Error()}
+	}
+	if uErr := c.SetVerdict(sessionid, res.Verdict, res.Detail); uErr != nil {
+		return res, uErr
+	}
+	return res, err
+}
+
+// StartScan to set a completed carve as pending and scan it in the background
+func (c *Carves) StartScan(scanner *Scanner, sessionid string) error {
+	carve, err := c.GetBySession(sessionid)
+	if err != nil {
+		return fmt.Errorf("getCarveBySessionID %v", err)
+	}
+	if carve.Status != StatusCompleted {
+		return fmt.Errorf("carve %s is not completed", carve.CarveID)
+	}
+	if err := c.SetVerdict(sessionid, ScanPending, ""); err != nil {
+		return err
+	}
+	go func() {
+		if _, err := c.Scan(scanner, sessionid); err != nil {
+			log.Printf("error scanning carve %s - %v", carve.CarveID, err)
+		}
+	}()
+	return nil
+}
+
+// OverrideSummary to describe the download of a blocked carve with an override, for the activity of environments
+func OverrideSummary(carve CarvedFile) string {
+	verdict := carve.ScanVerdict
+	if verdict == "" {
+		verdict = "not scanned"
+	}
+	return fmt.Sprintf("download of %s carve %s (%s) allowed by override", verdict, carve.CarveID, carve.Path)
+}
@@ -0,0 +1,88 @@
+package carves
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDownloadDecision(t *testing.T) {
+	for _, v := range []string{"", ScanPending, ScanClean, ScanSuspicious, ScanMalicious, ScanError} {
+		assert.Equal(t, DownloadAllow, DownloadDecision(ScanPolicyOff, v))
+		assert.Equal(t, DownloadAllow, DownloadDecision("", v))
+	}
+	assert.Equal(t, DownloadAllow, DownloadDecision(ScanPolicyEnforce, ScanClean))
+	assert.Equal(t, DownloadWarn, DownloadDecision(ScanPolicyEnforce, ScanSuspicious))
+	assert.Equal(t, DownloadBlock, DownloadDecision(ScanPolicyEnforce, ScanMalicious))
+	assert.Equal(t, DownloadBlock, DownloadDecision(ScanPolicyEnforce, ScanPending))
+	assert.Equal(t, DownloadBlock, DownloadDecision(ScanPolicyEnforce, ScanError))
+	assert.Equal(t, DownloadBlock, DownloadDecision(ScanPolicyEnforce, ""))
+}
+
+func TestParseScanResult(t *testing.T) {
+	res, err := ParseScanResult([]byte(`{"verdict":" Malicious ","detail":"EICAR-Test-File"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, ScanMalicious, res.Verdict)
+	assert.Equal(t, "EICAR-Test-File", res.Detail)
+	_, err = ParseScanResult([]byte(`{"verdict":"pending"}`))
+	assert.Error(t, err)
+	_, err = ParseScanResult([]byte(`clean`))
+	assert.Error(t, err)
+}
+
+func TestScannerWebhook(t *testing.T) {
+	var received string
+	var carveID string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = string(body)
+		carveID = r.Header.Get("X-Osctrl-Carve-Id")
+		_ = json.NewEncoder(w).Encode(ScanResult{Verdict: ScanSuspicious, Detail: "packed binary"})
+	}))
+	defer server.Close()
+	s := NewScanner(server.URL, 5)
+	assert.True(t, s.Webhook())
+	req := ScanRequest{CarveID: "carve-1", SessionID: "session-1", Path: "/tmp/a"}
+	res, err := s.Scan(context.Background(), req, strings.NewReader("carved content"))
+	assert.NoError(t, err)
+	assert.Equal(t, ScanSuspicious, res.Verdict)
+	assert.Equal(t, "carved content", received)
+	assert.Equal(t, "carve-1", carveID)
+	// Without content, the request with the URL is sent
+	req.URL = "https://bucket.s3.amazonaws.com/carve"
+	_, err = s.Scan(context.Background(), req, nil)
+	assert.NoError(t, err)
+	assert.Contains(t, received, `"url":"https://bucket.s3.amazonaws.com/carve"`)
+}
+
+func TestScannerWebhookError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	_, err := NewScanner(server.URL, 5).Scan(context.Background(), ScanRequest{}, strings.NewReader("x"))
+	assert.Error(t, err)
+}
+
+func TestScannerCommand(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "scan.sh")
+	content := "#!/bin/sh\nif grep -q EICAR; then echo '{\"verdict\":\"malicious\",\"detail\":\"'$OSCTRL_CARVE_ID'\"}'; else echo '{\"verdict\":\"clean\"}'; fi\n"
+	assert.NoError(t, os.WriteFile(script, []byte(content), 0755))
+	s := NewScanner(script, 5)
+	assert.False(t, s.Webhook())
+	res, err := s.Scan(context.Background(), ScanRequest{CarveID: "carve-2"}, strings.NewReader("X5O EICAR test"))
+	assert.NoError(t, err)
+	assert.Equal(t, ScanMalicious, res.Verdict)
+	assert.Equal(t, "carve-2", res.Detail)
+	res, err = s.Scan(context.Background(), ScanRequest{CarveID: "carve-3"}, strings.NewReader("nothing here"))
+	assert.NoError(t, err)
+	assert.Equal(t, ScanClean, res.Verdict)
+}
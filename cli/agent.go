@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/queries"
+	"github.com/jmpsec/osctrl/types"
+)
+
+const (
+	// Timeout for each request of synthetic agents to osctrl-tls
+	agentTimeout = 30 * time.Second
+	// Version of osquery reported by synthetic agents
+	agentOsqueryVersion = "5.9.1"
+	// Size of the blocks of files carved by synthetic agents
+	agentCarveBlockSize = 256
+)
+
+// syntheticAgent to act as an osquery node against osctrl-tls, sending the same requests as osquery
+// so the real handlers of enroll, config, logs, on-demand queries and carves are exercised
+type syntheticAgent struct {
+	URL         string
+	Environment string
+	Secret      string
+	UUID        string
+	Hostname    string
+	NodeKey     string
+	Paths       environments.EnvPaths
+	Client      *http.Client
+}
+
+// Helper to generate a random UUID for synthetic agents, as osquery reports it in system_info
+func randomAgentUUID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return strings.ToUpper(fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]))
+}
+
+// newSyntheticAgent to prepare a synthetic agent with a random identity, for an environment of osctrl-tls
+func newSyntheticAgent(tlsURL, env, secret, prefix string, insecure bool) *syntheticAgent {
+	uuid := randomAgentUUID()
+	client := &http.Client{Timeout: agentTimeout}
+	if insecure {
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	}
+	return &syntheticAgent{
+		URL:         strings.TrimSuffix(tlsURL, "/"),
+		Environment: env,
+		Secret:      secret,
+		UUID:        uuid,
+		Hostname:    prefix + "-" + strings.ToLower(uuid[:8]),
+		Paths:       environments.DefaultPaths(),
+		Client:      client,
+	}
+}
+
+// Helper to send a request to osctrl-tls, returning the body of the response
+func (a *syntheticAgent) post(path string, data interface{}) ([]byte, error) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling data - %v", err)
+	}
+	reqURL := fmt.Sprintf("%s/%s/%s", a.URL, a.Environment, path)
+	req, err := http.NewRequest(http.MethodPost, reqURL, bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("error preparing request - %v", err)
+	}
+	req.Header.Set(ContentType, JSONApplication)
+	req.Header.Set(UserAgent, "osquery/"+agentOsqueryVersion)
+	resp, err := a.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request - %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response - %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return body, fmt.Errorf("HTTP %d from %s - %s", resp.StatusCode, path, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+// Helper to check that osctrl-tls still knows the node_key of the agent
+func nodeValid(body []byte) error {
+	var r types.GenericResponse
+	if err := json.Unmarshal(body, &r); err != nil {
+		return fmt.Errorf("can not parse body - %v", err)
+	}
+	if r.NodeInvalid {
+		return fmt.Errorf("node_key rejected")
+	}
+	return nil
+}
+
+// Decorations of the logs of the agent
+func (a *syntheticAgent) decorations() types.LogDecorations {
+	return types.LogDecorations{
+		Username:       "root",
+		OsqueryUser:    "root",
+		LocalHostname:  a.Hostname,
+		Hostname:       a.Hostname,
+		OsqueryVersion: agentOsqueryVersion,
+	}
+}
+
+// Enroll to enroll the agent with the secret of the environment, keeping the node_key
+func (a *syntheticAgent) Enroll() error {
+	req := types.EnrollRequest{
+		EnrollSecret:   a.Secret,
+		HostIdentifier: a.UUID,
+		PlatformType:   "9",
+	}
+	req.HostDetails.EnrollOSVersion = types.OSVersionTable{
+		ID:           "22.04",
+		Codename:     "jammy",
+		Major:        "22",
+		Minor:        "04",
+		Name:         "Ubuntu",
+		Platform:     "ubuntu",
+		PlatformLike: "debian",
+		Version:      "22.04.3 LTS (Jammy Jellyfish)",
+	}
+	req.HostDetails.EnrollOsqueryInfo = types.OsqueryInfoTable{
+		BuildDistro:   "centos7",
+		BuildPlatform: "linux",
+		Extension:     "inactive",
+		InstanceID:    randomAgentUUID(),
+		PID:           "1",
+		StartTime:     strconv.FormatInt(time.Now().Unix(), 10),
+		UUID:          a.UUID,
+		Version:       agentOsqueryVersion,
+		Watcher:       "-1",
+	}
+	req.HostDetails.EnrollSystemInfo = types.SystemInfoTable{
+		ComputerName:     a.Hostname,
+		CPUBrand:         "Synthetic CPU",
+		CPULogicalCores:  "1",
+		CPUPhysicalCores: "1",
+		CPUType:          "x86_64",
+		Hostname:         a.Hostname,
+		LocalHostname:    a.Hostname,
+		PhysicalMemory:   "1073741824",
+		UUID:             a.UUID,
+	}
+	body, err := a.post(a.Paths.Enroll, req)
+	if err != nil {
+		return err
+	}
+	var r types.EnrollResponse
+	if err := json.Unmarshal(body, &r); err != nil {
+		return fmt.Errorf("can not parse body - %v", err)
+	}
+	if r.NodeInvalid || r.NodeKey == "" {
+		return fmt.Errorf("enroll rejected, check the secret and the platforms allowed in the environment")
+	}
+	a.NodeKey = r.NodeKey
+	return nil
+}
+
+// Config to get the configuration of the agent, returning its size
+func (a *syntheticAgent) Config() (int, error) {
+	body, err := a.post(a.Paths.Config, types.ConfigRequest{NodeKey: a.NodeKey})
+	if err != nil {
+		return 0, err
+	}
+	if err := nodeValid(body); err != nil {
+		return 0, err
+	}
+	return len(body), nil
+}
+
+// Flags to get the flags of the agent as osctrld does, returning how many flags there are
+func (a *syntheticAgent) Flags() (int, error) {
+	body, err := a.post(environments.DefaultFlagsPath, types.FlagsRequest{Secret: a.Secret, UUID: a.UUID})
+	if err != nil {
+		return 0, err
+	}
+	flags := strings.Count(string(body), "--")
+	if flags == 0 {
+		return 0, fmt.Errorf("no flags returned")
+	}
+	return flags, nil
+}
+
+// Helper to send logs of a type
+func (a *syntheticAgent) log(logType string, data interface{}) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("error marshaling logs - %v", err)
+	}
+	body, err := a.post(a.Paths.Log, types.LogRequest{NodeKey: a.NodeKey, LogType: logType, Data: raw})
+	if err != nil {
+		return err
+	}
+	return nodeValid(body)
+}
+
+// StatusLog to send one status log line
+func (a *syntheticAgent) StatusLog(message string) error {
+	now := time.Now()
+	return a.log(types.StatusLog, []types.LogStatusData{{
+		Line:           "1",
+		Message:        message,
+		Version:        agentOsqueryVersion,
+		Filename:       "smoke.cpp",
+		Severity:       "0",
+		UnixTime:       types.StringInt(now.Unix()),
+		Decorations:    a.decorations(),
+		CalendarTime:   now.UTC().Format(time.ANSIC) + " UTC",
+		HostIdentifier: a.UUID,
+	}})
+}
+
+// ResultLog to send one result log of a scheduled query with its rows as a snapshot
+func (a *syntheticAgent) ResultLog(name string, rows []map[string]string) error {
+	now := time.Now()
+	var snapshot []json.RawMessage
+	for _, r := range rows {
+		raw, err := json.Marshal(r)
+		if err != nil {
+			return fmt.Errorf("error marshaling rows - %v", err)
+		}
+		snapshot = append(snapshot, raw)
+	}
+	return a.log(types.ResultLog, []types.LogResultData{{
+		Name:           name,
+		Action:         "snapshot",
+		Snapshot:       snapshot,
+		UnixTime:       types.StringInt(now.Unix()),
+		Decorations:    a.decorations(),
+		CalendarTime:   now.UTC().Format(time.ANSIC) + " UTC",
+		HostIdentifier: a.UUID,
+	}})
+}
+
+// Read to get the on-demand queries pending for the agent
+func (a *syntheticAgent) Read() (queries.QueryReadQueries, error) {
+	body, err := a.post(a.Paths.QueryRead, types.QueryReadRequest{NodeKey: a.NodeKey})
+	if err != nil {
+		return nil, err
+	}
+	var r types.QueryReadResponse
+	if err := json.Unmarshal(body, &r); err != nil {
+		return nil, fmt.Errorf("can not parse body - %v", err)
+	}
+	if r.NodeInvalid {
+		return nil, fmt.Errorf("node_key rejected")
+	}
+	return r.Queries, nil
+}
+
+// Write to answer an on-demand query with its rows
+func (a *syntheticAgent) Write(name string, rows interface{}) error {
+	raw, err := json.Marshal(rows)
+	if err != nil {
+		return fmt.Errorf("error marshaling rows - %v", err)
+	}
+	body, err := a.post(a.Paths.QueryWrite, types.QueryWriteRequest{
+		Queries:  types.QueryWriteQueries{name: raw},
+		Statuses: types.QueryWriteStatuses{name: 0},
+		Messages: types.QueryWriteMessages{name: ""},
+		Stats:    types.QueryWriteStats{name: {WallTimeMs: 1}},
+		NodeKey:  a.NodeKey,
+	})
+	if err != nil {
+		return err
+	}
+	return nodeValid(body)
+}
+
+// Carve to carve the content of a file for a carve query, as osquery does: scheduling the carve in the answer
+// of the query, then sending its blocks. The carve query must be pending for the agent.
+func (a *syntheticAgent) Carve(name, path string, content []byte) error {
+	guid := strings.ToLower(randomAgentUUID())
+	scheduled := []types.QueryCarveScheduled{{
+		Time:      strconv.FormatInt(time.Now().Unix(), 10),
+		Path:      path,
+		Status:    "SCHEDULED",
+		CarveGUID: guid,
+		RequestID: name,
+		Carve:     "1",
+	}}
+	if err := a.Write(name, scheduled); err != nil {
+		return fmt.Errorf("error scheduling carve - %v", err)
+	}
+	blocks := (len(content) + agentCarveBlockSize - 1) / agentCarveBlockSize
+	body, err := a.post(a.Paths.CarverInit, types.CarveInitRequest{
+		BlockCount: blocks,
+		BlockSize:  agentCarveBlockSize,
+		CarveSize:  len(content),
+		CarveID:    guid,
+		RequestID:  name,
+		NodeKey:    a.NodeKey,
+	})
+	if err != nil {
+		return err
+	}
+	var init types.CarveInitResponse
+	if err := json.Unmarshal(body, &init); err != nil {
+		return fmt.Errorf("can not parse body - %v", err)
+	}
+	if !init.Success {
+		return fmt.Errorf("carve init rejected")
+	}
+	for i := 0; i < blocks; i++ {
+		end := (i + 1) * agentCarveBlockSize
+		if end > len(content) {
+			end = len(content)
+		}
+		body, err := a.post(a.Paths.CarverBlock, types.CarveBlockRequest{
+			BlockID:   i,
+			SessionID: init.SessionID,
+			RequestID: name,
+			Data:      base64.StdEncoding.EncodeToString(content[i*agentCarveBlockSize : end]),
+		})
+		if err != nil {
+			return err
+		}
+		var block types.CarveBlockResponse
+		if err := json.Unmarshal(body, &block); err != nil {
+			return fmt.Errorf("can not parse body - %v", err)
+		}
+		if !block.Success {
+			return fmt.Errorf("carve block %d rejected", i)
+		}
+	}
+	return nil
+}
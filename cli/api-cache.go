@@ -0,0 +1,25 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/jmpsec/osctrl/cache"
+)
+
+// APIStats for the stats path
+const APIStats = "/stats"
+
+// GetCacheStats to retrieve the keys in redis by family from osctrl
+func (api *OsctrlAPI) GetCacheStats(sample int) (cache.CacheStats, error) {
+	var stats cache.CacheStats
+	reqURL := fmt.Sprintf("%s%s%s/cache?sample=%d", api.Configuration.URL, APIPath, APIStats, sample)
+	rawStats, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return stats, fmt.Errorf("error api request - %v - %s", err, string(rawStats))
+	}
+	if err := json.Unmarshal(rawStats, &stats); err != nil {
+		return stats, fmt.Errorf("can not parse body - %v", err)
+	}
+	return stats, nil
+}
@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/jmpsec/osctrl/carves"
+	"github.com/jmpsec/osctrl/queries"
 	"github.com/jmpsec/osctrl/types"
 )
 
@@ -38,6 +39,20 @@ func (api *OsctrlAPI) GetCarve(env, name string) (carves.CarvedFile, error) {
 	return c, nil
 }
 
+// GetCarveFiles to retrieve the files carved by one carve from osctrl
+func (api *OsctrlAPI) GetCarveFiles(env, name string) ([]carves.CarvedFile, error) {
+	var cs []carves.CarvedFile
+	reqURL := fmt.Sprintf("%s%s%s/%s/%s", api.Configuration.URL, APIPath, APICarves, env, name)
+	rawCs, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return cs, fmt.Errorf("error api request - %v - %s", err, string(rawCs))
+	}
+	if err := json.Unmarshal(rawCs, &cs); err != nil {
+		return cs, fmt.Errorf("can not parse body - %v", err)
+	}
+	return cs, nil
+}
+
 // DeleteCarve to delete carve from osctrl
 func (api *OsctrlAPI) DeleteCarve(env, identifier string) error {
 	return nil
@@ -48,12 +63,18 @@ func (api *OsctrlAPI) CompleteCarve(env, identifier string) error {
 	return nil
 }
 
-// RunCarve to initiate a carve in osctrl
-func (api *OsctrlAPI) RunCarve(env, uuid, path string) (types.ApiQueriesResponse, error) {
-	c := types.ApiDistributedCarveRequest{
-		UUID: uuid,
-		Path: path,
+// ScanCarve to scan again a completed carve in osctrl
+func (api *OsctrlAPI) ScanCarve(env, session string) error {
+	reqURL := fmt.Sprintf("%s%s%s/%s/scan/%s", api.Configuration.URL, APIPath, APICarves, env, session)
+	rawS, err := api.PostGeneric(reqURL, nil)
+	if err != nil {
+		return fmt.Errorf("error api request - %v - %s", err, string(rawS))
 	}
+	return nil
+}
+
+// RunCarve to initiate a carve in osctrl
+func (api *OsctrlAPI) RunCarve(env string, c types.ApiDistributedCarveRequest) (types.ApiQueriesResponse, error) {
 	var r types.ApiQueriesResponse
 	reqURL := fmt.Sprintf("%s%s%s/%s", api.Configuration.URL, APIPath, APICarves, env)
 	jsonMessage, err := json.Marshal(c)
@@ -70,3 +91,46 @@ func (api *OsctrlAPI) RunCarve(env, uuid, path string) (types.ApiQueriesResponse
 	}
 	return r, nil
 }
+
+// GetRecurringCarves to retrieve the recurring carves of an environment from osctrl
+func (api *OsctrlAPI) GetRecurringCarves(env string) ([]queries.RecurringCarveDetail, error) {
+	var rs []queries.RecurringCarveDetail
+	reqURL := fmt.Sprintf("%s%s%s/%s/recurring", api.Configuration.URL, APIPath, APICarves, env)
+	rawRs, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return rs, fmt.Errorf("error api request - %v - %s", err, string(rawRs))
+	}
+	if err := json.Unmarshal(rawRs, &rs); err != nil {
+		return rs, fmt.Errorf("can not parse body - %v", err)
+	}
+	return rs, nil
+}
+
+// CreateRecurringCarve to create a carve launched on a recurrence in osctrl
+func (api *OsctrlAPI) CreateRecurringCarve(env string, c types.ApiRecurringCarveRequest) (types.ApiQueriesResponse, error) {
+	var r types.ApiQueriesResponse
+	reqURL := fmt.Sprintf("%s%s%s/%s/recurring", api.Configuration.URL, APIPath, APICarves, env)
+	jsonMessage, err := json.Marshal(c)
+	if err != nil {
+		log.Printf("error marshaling data %s", err)
+	}
+	jsonParam := strings.NewReader(string(jsonMessage))
+	rawR, err := api.PostGeneric(reqURL, jsonParam)
+	if err != nil {
+		return r, fmt.Errorf("error api request - %v - %s", err, string(rawR))
+	}
+	if err := json.Unmarshal(rawR, &r); err != nil {
+		return r, fmt.Errorf("can not parse body - %v", err)
+	}
+	return r, nil
+}
+
+// ActionRecurringCarve to pause, resume or stop a recurring carve in osctrl
+func (api *OsctrlAPI) ActionRecurringCarve(env, name, action string) error {
+	reqURL := fmt.Sprintf("%s%s%s/%s/recurring/%s/%s", api.Configuration.URL, APIPath, APICarves, env, name, action)
+	rawR, err := api.PostGeneric(reqURL, nil)
+	if err != nil {
+		return fmt.Errorf("error api request - %v - %s", err, string(rawR))
+	}
+	return nil
+}
@@ -0,0 +1,752 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/jmpsec/osctrl/queries"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/users"
+)
+
+// GetEnvironments to retrieve all environments from osctrl
+func (api *OsctrlAPI) GetEnvironments() ([]environments.TLSEnvironment, error) {
+	var envs []environments.TLSEnvironment
+	reqURL := fmt.Sprintf("%s%s%s", api.Configuration.URL, APIPath, APIEnvironments)
+	rawEnvs, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return envs, fmt.Errorf("error api request - %v - %s", err, string(rawEnvs))
+	}
+	if err := json.Unmarshal(rawEnvs, &envs); err != nil {
+		return envs, fmt.Errorf("can not parse body - %v", err)
+	}
+	return envs, nil
+}
+
+// GetCertificateStatus to retrieve the propagation of the certificate of an environment
+func (api *OsctrlAPI) GetCertificateStatus(env string) (environments.CertificateStatus, error) {
+	var status environments.CertificateStatus
+	reqURL := fmt.Sprintf("%s%s%s/%s/certificate/status", api.Configuration.URL, APIPath, APIEnvironments, env)
+	rawStatus, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return status, fmt.Errorf("error api request - %v - %s", err, string(rawStatus))
+	}
+	if err := json.Unmarshal(rawStatus, &status); err != nil {
+		return status, fmt.Errorf("can not parse body - %v", err)
+	}
+	return status, nil
+}
+
+// StageCertificate to upload a new version of the certificate of an environment
+func (api *OsctrlAPI) StageCertificate(env, certificate string, activateAt time.Time) (types.ApiGenericResponse, error) {
+	c := types.ApiCertificateRequest{
+		Certificate: certificate,
+		ActivateAt:  activateAt,
+	}
+	var r types.ApiGenericResponse
+	reqURL := fmt.Sprintf("%s%s%s/%s/certificate", api.Configuration.URL, APIPath, APIEnvironments, env)
+	jsonMessage, err := json.Marshal(c)
+	if err != nil {
+		log.Printf("error marshaling data %s", err)
+	}
+	jsonParam := strings.NewReader(string(jsonMessage))
+	rawC, err := api.PostGeneric(reqURL, jsonParam)
+	if err != nil {
+		return r, fmt.Errorf("error api request - %v - %s", err, string(rawC))
+	}
+	if err := json.Unmarshal(rawC, &r); err != nil {
+		return r, fmt.Errorf("can not parse body - %v", err)
+	}
+	return r, nil
+}
+
+// GetEnrollFields to retrieve the enroll metadata fields of an environment
+func (api *OsctrlAPI) GetEnrollFields(env string) ([]environments.EnrollField, error) {
+	var fields []environments.EnrollField
+	reqURL := fmt.Sprintf("%s%s%s/%s/enroll-fields", api.Configuration.URL, APIPath, APIEnvironments, env)
+	rawFields, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return fields, fmt.Errorf("error api request - %v - %s", err, string(rawFields))
+	}
+	if err := json.Unmarshal(rawFields, &fields); err != nil {
+		return fields, fmt.Errorf("can not parse body - %v", err)
+	}
+	return fields, nil
+}
+
+// SetEnrollField to create or update an enroll metadata field of an environment
+func (api *OsctrlAPI) SetEnrollField(env string, f types.ApiEnrollFieldRequest) (environments.EnrollField, error) {
+	var field environments.EnrollField
+	reqURL := fmt.Sprintf("%s%s%s/%s/enroll-fields", api.Configuration.URL, APIPath, APIEnvironments, env)
+	jsonMessage, err := json.Marshal(f)
+	if err != nil {
+		log.Printf("error marshaling data %s", err)
+	}
+	jsonParam := strings.NewReader(string(jsonMessage))
+	rawField, err := api.PostGeneric(reqURL, jsonParam)
+	if err != nil {
+		return field, fmt.Errorf("error api request - %v - %s", err, string(rawField))
+	}
+	if err := json.Unmarshal(rawField, &field); err != nil {
+		return field, fmt.Errorf("can not parse body - %v", err)
+	}
+	return field, nil
+}
+
+// DeleteEnrollField to delete an enroll metadata field of an environment
+func (api *OsctrlAPI) DeleteEnrollField(env, name string) (types.ApiGenericResponse, error) {
+	var r types.ApiGenericResponse
+	reqURL := fmt.Sprintf("%s%s%s/%s/enroll-fields/delete", api.Configuration.URL, APIPath, APIEnvironments, env)
+	jsonMessage, err := json.Marshal(types.ApiEnrollFieldRequest{Name: name})
+	if err != nil {
+		log.Printf("error marshaling data %s", err)
+	}
+	jsonParam := strings.NewReader(string(jsonMessage))
+	rawR, err := api.PostGeneric(reqURL, jsonParam)
+	if err != nil {
+		return r, fmt.Errorf("error api request - %v - %s", err, string(rawR))
+	}
+	if err := json.Unmarshal(rawR, &r); err != nil {
+		return r, fmt.Errorf("can not parse body - %v", err)
+	}
+	return r, nil
+}
+
+// GetProfiles to retrieve the performance profiles of an environment
+func (api *OsctrlAPI) GetProfiles(env string) ([]environments.PerformanceProfile, error) {
+	var profiles []environments.PerformanceProfile
+	reqURL := fmt.Sprintf("%s%s%s/%s/profiles", api.Configuration.URL, APIPath, APIEnvironments, env)
+	rawProfiles, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return profiles, fmt.Errorf("error api request - %v - %s", err, string(rawProfiles))
+	}
+	if err := json.Unmarshal(rawProfiles, &profiles); err != nil {
+		return profiles, fmt.Errorf("can not parse body - %v", err)
+	}
+	return profiles, nil
+}
+
+// SetProfile to create or update a performance profile of an environment
+func (api *OsctrlAPI) SetProfile(env string, p types.ApiProfileRequest) (environments.PerformanceProfile, error) {
+	var profile environments.PerformanceProfile
+	reqURL := fmt.Sprintf("%s%s%s/%s/profiles", api.Configuration.URL, APIPath, APIEnvironments, env)
+	jsonMessage, err := json.Marshal(p)
+	if err != nil {
+		log.Printf("error marshaling data %s", err)
+	}
+	jsonParam := strings.NewReader(string(jsonMessage))
+	rawProfile, err := api.PostGeneric(reqURL, jsonParam)
+	if err != nil {
+		return profile, fmt.Errorf("error api request - %v - %s", err, string(rawProfile))
+	}
+	if err := json.Unmarshal(rawProfile, &profile); err != nil {
+		return profile, fmt.Errorf("can not parse body - %v", err)
+	}
+	return profile, nil
+}
+
+// DeleteProfile to delete a performance profile of an environment
+func (api *OsctrlAPI) DeleteProfile(env, name string) (types.ApiGenericResponse, error) {
+	var r types.ApiGenericResponse
+	reqURL := fmt.Sprintf("%s%s%s/%s/profiles/delete", api.Configuration.URL, APIPath, APIEnvironments, env)
+	jsonMessage, err := json.Marshal(types.ApiProfileRequest{Name: name})
+	if err != nil {
+		log.Printf("error marshaling data %s", err)
+	}
+	jsonParam := strings.NewReader(string(jsonMessage))
+	rawR, err := api.PostGeneric(reqURL, jsonParam)
+	if err != nil {
+		return r, fmt.Errorf("error api request - %v - %s", err, string(rawR))
+	}
+	if err := json.Unmarshal(rawR, &r); err != nil {
+		return r, fmt.Errorf("can not parse body - %v", err)
+	}
+	return r, nil
+}
+
+// GetDenylist to retrieve the active scheduled query denylist of an environment
+func (api *OsctrlAPI) GetDenylist(env string) ([]environments.ScheduleDeny, error) {
+	var denylist []environments.ScheduleDeny
+	reqURL := fmt.Sprintf("%s%s%s/%s/denylist", api.Configuration.URL, APIPath, APIEnvironments, env)
+	rawDenylist, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return denylist, fmt.Errorf("error api request - %v - %s", err, string(rawDenylist))
+	}
+	if err := json.Unmarshal(rawDenylist, &denylist); err != nil {
+		return denylist, fmt.Errorf("can not parse body - %v", err)
+	}
+	return denylist, nil
+}
+
+// AddDeny to add an entry to the scheduled query denylist of an environment
+func (api *OsctrlAPI) AddDeny(env string, d types.ApiDenylistRequest) (environments.ScheduleDeny, error) {
+	var entry environments.ScheduleDeny
+	reqURL := fmt.Sprintf("%s%s%s/%s/denylist", api.Configuration.URL, APIPath, APIEnvironments, env)
+	jsonMessage, err := json.Marshal(d)
+	if err != nil {
+		log.Printf("error marshaling data %s", err)
+	}
+	jsonParam := strings.NewReader(string(jsonMessage))
+	rawEntry, err := api.PostGeneric(reqURL, jsonParam)
+	if err != nil {
+		return entry, fmt.Errorf("error api request - %v - %s", err, string(rawEntry))
+	}
+	if err := json.Unmarshal(rawEntry, &entry); err != nil {
+		return entry, fmt.Errorf("can not parse body - %v", err)
+	}
+	return entry, nil
+}
+
+// LiftDeny to lift an entry of the scheduled query denylist of an environment
+func (api *OsctrlAPI) LiftDeny(env string, id uint) (types.ApiGenericResponse, error) {
+	var r types.ApiGenericResponse
+	reqURL := fmt.Sprintf("%s%s%s/%s/denylist/lift", api.Configuration.URL, APIPath, APIEnvironments, env)
+	jsonMessage, err := json.Marshal(types.ApiDenylistRequest{ID: id})
+	if err != nil {
+		log.Printf("error marshaling data %s", err)
+	}
+	jsonParam := strings.NewReader(string(jsonMessage))
+	rawR, err := api.PostGeneric(reqURL, jsonParam)
+	if err != nil {
+		return r, fmt.Errorf("error api request - %v - %s", err, string(rawR))
+	}
+	if err := json.Unmarshal(rawR, &r); err != nil {
+		return r, fmt.Errorf("can not parse body - %v", err)
+	}
+	return r, nil
+}
+
+// GetConfigVersions to retrieve the configuration versions of an environment, newest first
+func (api *OsctrlAPI) GetConfigVersions(env string) ([]environments.ConfigVersion, error) {
+	var versions []environments.ConfigVersion
+	reqURL := fmt.Sprintf("%s%s%s/%s/config/versions", api.Configuration.URL, APIPath, APIEnvironments, env)
+	rawVersions, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return versions, fmt.Errorf("error api request - %v - %s", err, string(rawVersions))
+	}
+	if err := json.Unmarshal(rawVersions, &versions); err != nil {
+		return versions, fmt.Errorf("can not parse body - %v", err)
+	}
+	return versions, nil
+}
+
+// RollbackConfig to restore the configuration of a version of an environment, saved as a new version
+func (api *OsctrlAPI) RollbackConfig(env string, version int, note string) (environments.ConfigVersion, error) {
+	var v environments.ConfigVersion
+	reqURL := fmt.Sprintf("%s%s%s/%s/config/rollback", api.Configuration.URL, APIPath, APIEnvironments, env)
+	jsonMessage, err := json.Marshal(types.ApiRollbackRequest{Version: version, Note: note})
+	if err != nil {
+		log.Printf("error marshaling data %s", err)
+	}
+	jsonParam := strings.NewReader(string(jsonMessage))
+	rawV, err := api.PostGeneric(reqURL, jsonParam)
+	if err != nil {
+		return v, fmt.Errorf("error api request - %v - %s", err, string(rawV))
+	}
+	if err := json.Unmarshal(rawV, &v); err != nil {
+		return v, fmt.Errorf("can not parse body - %v", err)
+	}
+	return v, nil
+}
+
+// ScheduleConfig to schedule a change of the configuration of an environment
+func (api *OsctrlAPI) ScheduleConfig(env string, req types.ApiScheduleConfigRequest) (environments.ScheduledChange, error) {
+	var c environments.ScheduledChange
+	reqURL := fmt.Sprintf("%s%s%s/%s/config/scheduled", api.Configuration.URL, APIPath, APIEnvironments, env)
+	jsonMessage, err := json.Marshal(req)
+	if err != nil {
+		log.Printf("error marshaling data %s", err)
+	}
+	jsonParam := strings.NewReader(string(jsonMessage))
+	rawC, err := api.PostGeneric(reqURL, jsonParam)
+	if err != nil {
+		return c, fmt.Errorf("error api request - %v - %s", err, string(rawC))
+	}
+	if err := json.Unmarshal(rawC, &c); err != nil {
+		return c, fmt.Errorf("can not parse body - %v", err)
+	}
+	return c, nil
+}
+
+// CancelScheduledConfig to cancel the pending scheduled change of the configuration of an environment
+func (api *OsctrlAPI) CancelScheduledConfig(env string) (environments.ScheduledChange, error) {
+	var c environments.ScheduledChange
+	reqURL := fmt.Sprintf("%s%s%s/%s/config/scheduled/cancel", api.Configuration.URL, APIPath, APIEnvironments, env)
+	rawC, err := api.PostGeneric(reqURL, nil)
+	if err != nil {
+		return c, fmt.Errorf("error api request - %v - %s", err, string(rawC))
+	}
+	if err := json.Unmarshal(rawC, &c); err != nil {
+		return c, fmt.Errorf("can not parse body - %v", err)
+	}
+	return c, nil
+}
+
+// VerifyConfig to verify the flags and configuration of an environment, filtered by version, platform and profile
+func (api *OsctrlAPI) VerifyConfig(env string, filter url.Values, req types.ApiConfigImpactRequest) (environments.VerifyReport, error) {
+	var report environments.VerifyReport
+	reqURL := fmt.Sprintf("%s%s%s/%s/config/verify", api.Configuration.URL, APIPath, APIEnvironments, env)
+	if len(filter) > 0 {
+		reqURL += "?" + filter.Encode()
+	}
+	jsonMessage, err := json.Marshal(req)
+	if err != nil {
+		log.Printf("error marshaling data %s", err)
+	}
+	jsonParam := strings.NewReader(string(jsonMessage))
+	rawReport, err := api.PostGeneric(reqURL, jsonParam)
+	if err != nil {
+		return report, fmt.Errorf("error api request - %v - %s", err, string(rawReport))
+	}
+	if err := json.Unmarshal(rawReport, &report); err != nil {
+		return report, fmt.Errorf("can not parse body - %v", err)
+	}
+	return report, nil
+}
+
+// CreateEnvironment to create an environment from a template
+func (api *OsctrlAPI) CreateEnvironment(name, hostname, template string) (environments.TLSEnvironment, error) {
+	var e environments.TLSEnvironment
+	reqURL := fmt.Sprintf("%s%s%s", api.Configuration.URL, APIPath, APIEnvironments)
+	jsonMessage, err := json.Marshal(types.ApiEnvironmentRequest{Name: name, Hostname: hostname, Template: template})
+	if err != nil {
+		log.Printf("error marshaling data %s", err)
+	}
+	jsonParam := strings.NewReader(string(jsonMessage))
+	rawE, err := api.PostGeneric(reqURL, jsonParam)
+	if err != nil {
+		return e, fmt.Errorf("error api request - %v - %s", err, string(rawE))
+	}
+	if err := json.Unmarshal(rawE, &e); err != nil {
+		return e, fmt.Errorf("can not parse body - %v", err)
+	}
+	return e, nil
+}
+
+// GetEnvTemplates to retrieve all environment templates
+func (api *OsctrlAPI) GetEnvTemplates() ([]environments.EnvTemplate, error) {
+	var ts []environments.EnvTemplate
+	reqURL := fmt.Sprintf("%s%s%s/templates", api.Configuration.URL, APIPath, APIEnvironments)
+	rawTs, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return ts, fmt.Errorf("error api request - %v - %s", err, string(rawTs))
+	}
+	if err := json.Unmarshal(rawTs, &ts); err != nil {
+		return ts, fmt.Errorf("can not parse body - %v", err)
+	}
+	return ts, nil
+}
+
+// GetAPIKeys to retrieve the service keys of an environment
+func (api *OsctrlAPI) GetAPIKeys(env string) ([]users.APIKey, error) {
+	var keys []users.APIKey
+	reqURL := fmt.Sprintf("%s%s%s/%s/apikeys", api.Configuration.URL, APIPath, APIEnvironments, env)
+	rawKeys, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return keys, fmt.Errorf("error api request - %v - %s", err, string(rawKeys))
+	}
+	if err := json.Unmarshal(rawKeys, &keys); err != nil {
+		return keys, fmt.Errorf("can not parse body - %v", err)
+	}
+	return keys, nil
+}
+
+// NewAPIKey to create or rotate a service key of an environment
+func (api *OsctrlAPI) NewAPIKey(env string, k types.ApiKeyRequest) (types.ApiKeyResponse, error) {
+	var key types.ApiKeyResponse
+	reqURL := fmt.Sprintf("%s%s%s/%s/apikeys", api.Configuration.URL, APIPath, APIEnvironments, env)
+	jsonMessage, err := json.Marshal(k)
+	if err != nil {
+		log.Printf("error marshaling data %s", err)
+	}
+	jsonParam := strings.NewReader(string(jsonMessage))
+	rawKey, err := api.PostGeneric(reqURL, jsonParam)
+	if err != nil {
+		return key, fmt.Errorf("error api request - %v - %s", err, string(rawKey))
+	}
+	if err := json.Unmarshal(rawKey, &key); err != nil {
+		return key, fmt.Errorf("can not parse body - %v", err)
+	}
+	return key, nil
+}
+
+// RevokeAPIKey to revoke a service key of an environment
+func (api *OsctrlAPI) RevokeAPIKey(env, keyID string) (types.ApiGenericResponse, error) {
+	var r types.ApiGenericResponse
+	reqURL := fmt.Sprintf("%s%s%s/%s/apikeys/revoke", api.Configuration.URL, APIPath, APIEnvironments, env)
+	jsonMessage, err := json.Marshal(types.ApiKeyRequest{KeyID: keyID})
+	if err != nil {
+		log.Printf("error marshaling data %s", err)
+	}
+	jsonParam := strings.NewReader(string(jsonMessage))
+	rawR, err := api.PostGeneric(reqURL, jsonParam)
+	if err != nil {
+		return r, fmt.Errorf("error api request - %v - %s", err, string(rawR))
+	}
+	if err := json.Unmarshal(rawR, &r); err != nil {
+		return r, fmt.Errorf("can not parse body - %v", err)
+	}
+	return r, nil
+}
+
+// GetWindowsEvents to retrieve the Windows event channels of an environment with what they generate
+func (api *OsctrlAPI) GetWindowsEvents(env string) (environments.WindowsEventsPreview, error) {
+	var preview environments.WindowsEventsPreview
+	reqURL := fmt.Sprintf("%s%s%s/%s/windows-events", api.Configuration.URL, APIPath, APIEnvironments, env)
+	rawPreview, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return preview, fmt.Errorf("error api request - %v - %s", err, string(rawPreview))
+	}
+	if err := json.Unmarshal(rawPreview, &preview); err != nil {
+		return preview, fmt.Errorf("can not parse body - %v", err)
+	}
+	return preview, nil
+}
+
+// SetWindowsEvent to create or update a Windows event channel of an environment
+func (api *OsctrlAPI) SetWindowsEvent(env string, c types.ApiWindowsEventRequest) (environments.WindowsEventsPreview, error) {
+	var preview environments.WindowsEventsPreview
+	reqURL := fmt.Sprintf("%s%s%s/%s/windows-events", api.Configuration.URL, APIPath, APIEnvironments, env)
+	jsonMessage, err := json.Marshal(c)
+	if err != nil {
+		log.Printf("error marshaling data %s", err)
+	}
+	jsonParam := strings.NewReader(string(jsonMessage))
+	rawPreview, err := api.PostGeneric(reqURL, jsonParam)
+	if err != nil {
+		return preview, fmt.Errorf("error api request - %v - %s", err, string(rawPreview))
+	}
+	if err := json.Unmarshal(rawPreview, &preview); err != nil {
+		return preview, fmt.Errorf("can not parse body - %v", err)
+	}
+	return preview, nil
+}
+
+// DeleteWindowsEvent to delete a Windows event channel of an environment
+func (api *OsctrlAPI) DeleteWindowsEvent(env, channel string) (types.ApiGenericResponse, error) {
+	var r types.ApiGenericResponse
+	reqURL := fmt.Sprintf("%s%s%s/%s/windows-events/delete", api.Configuration.URL, APIPath, APIEnvironments, env)
+	jsonMessage, err := json.Marshal(types.ApiWindowsEventRequest{Channel: channel})
+	if err != nil {
+		log.Printf("error marshaling data %s", err)
+	}
+	jsonParam := strings.NewReader(string(jsonMessage))
+	rawR, err := api.PostGeneric(reqURL, jsonParam)
+	if err != nil {
+		return r, fmt.Errorf("error api request - %v - %s", err, string(rawR))
+	}
+	if err := json.Unmarshal(rawR, &r); err != nil {
+		return r, fmt.Errorf("can not parse body - %v", err)
+	}
+	return r, nil
+}
+
+// ExportWindowsEvents to retrieve the Windows event channels of an environment to import them in another one
+func (api *OsctrlAPI) ExportWindowsEvents(env string) ([]environments.WindowsEventSetting, error) {
+	var settings []environments.WindowsEventSetting
+	reqURL := fmt.Sprintf("%s%s%s/%s/windows-events/export", api.Configuration.URL, APIPath, APIEnvironments, env)
+	rawSettings, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return settings, fmt.Errorf("error api request - %v - %s", err, string(rawSettings))
+	}
+	if err := json.Unmarshal(rawSettings, &settings); err != nil {
+		return settings, fmt.Errorf("can not parse body - %v", err)
+	}
+	return settings, nil
+}
+
+// ImportWindowsEvents to replace all the Windows event channels of an environment
+func (api *OsctrlAPI) ImportWindowsEvents(env string, settings []environments.WindowsEventSetting) (environments.WindowsEventsPreview, error) {
+	var preview environments.WindowsEventsPreview
+	reqURL := fmt.Sprintf("%s%s%s/%s/windows-events/import", api.Configuration.URL, APIPath, APIEnvironments, env)
+	jsonMessage, err := json.Marshal(settings)
+	if err != nil {
+		log.Printf("error marshaling data %s", err)
+	}
+	jsonParam := strings.NewReader(string(jsonMessage))
+	rawPreview, err := api.PostGeneric(reqURL, jsonParam)
+	if err != nil {
+		return preview, fmt.Errorf("error api request - %v - %s", err, string(rawPreview))
+	}
+	if err := json.Unmarshal(rawPreview, &preview); err != nil {
+		return preview, fmt.Errorf("can not parse body - %v", err)
+	}
+	return preview, nil
+}
+
+// GetActivity to retrieve one page of the activity of an environment, filtered by from, to, kind, actor, page and limit
+func (api *OsctrlAPI) GetActivity(env string, filter url.Values) (types.ApiActivityResponse, error) {
+	var activity types.ApiActivityResponse
+	reqURL := fmt.Sprintf("%s%s%s/%s/activity", api.Configuration.URL, APIPath, APIEnvironments, env)
+	if len(filter) > 0 {
+		reqURL += "?" + filter.Encode()
+	}
+	rawActivity, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return activity, fmt.Errorf("error api request - %v - %s", err, string(rawActivity))
+	}
+	if err := json.Unmarshal(rawActivity, &activity); err != nil {
+		return activity, fmt.Errorf("can not parse body - %v", err)
+	}
+	return activity, nil
+}
+
+// GetHistory to retrieve the daily node count history of an environment, filtered by metric, from and to
+func (api *OsctrlAPI) GetHistory(env string, filter url.Values) ([]nodes.NodeCountSnapshot, error) {
+	var history []nodes.NodeCountSnapshot
+	reqURL := fmt.Sprintf("%s%s%s/%s/history", api.Configuration.URL, APIPath, APIEnvironments, env)
+	if len(filter) > 0 {
+		reqURL += "?" + filter.Encode()
+	}
+	rawHistory, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return history, fmt.Errorf("error api request - %v - %s", err, string(rawHistory))
+	}
+	if err := json.Unmarshal(rawHistory, &history); err != nil {
+		return history, fmt.Errorf("can not parse body - %v", err)
+	}
+	return history, nil
+}
+
+// GetEnvStorage to retrieve the storage used by an environment
+func (api *OsctrlAPI) GetEnvStorage(env string, filter url.Values) (environments.StorageReport, error) {
+	var report environments.StorageReport
+	reqURL := fmt.Sprintf("%s%s%s/%s/storage", api.Configuration.URL, APIPath, APIEnvironments, env)
+	if len(filter) > 0 {
+		reqURL += "?" + filter.Encode()
+	}
+	rawReport, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return report, fmt.Errorf("error api request - %v - %s", err, string(rawReport))
+	}
+	if err := json.Unmarshal(rawReport, &report); err != nil {
+		return report, fmt.Errorf("can not parse body - %v", err)
+	}
+	return report, nil
+}
+
+// SetStorageQuota to set the storage quota of an environment and the actions when it is exceeded
+func (api *OsctrlAPI) SetStorageQuota(env string, q types.ApiStorageQuotaRequest) (environments.StorageQuota, error) {
+	var quota environments.StorageQuota
+	reqURL := fmt.Sprintf("%s%s%s/%s/storage/quota", api.Configuration.URL, APIPath, APIEnvironments, env)
+	jsonMessage, err := json.Marshal(q)
+	if err != nil {
+		log.Printf("error marshaling data %s", err)
+	}
+	jsonParam := strings.NewReader(string(jsonMessage))
+	rawQuota, err := api.PostGeneric(reqURL, jsonParam)
+	if err != nil {
+		return quota, fmt.Errorf("error api request - %v - %s", err, string(rawQuota))
+	}
+	if err := json.Unmarshal(rawQuota, &quota); err != nil {
+		return quota, fmt.Errorf("can not parse body - %v", err)
+	}
+	return quota, nil
+}
+
+// GetCoverageEstimate to estimate how many nodes of an environment are expected to check in within a window
+func (api *OsctrlAPI) GetCoverageEstimate(env string, filter url.Values) (nodes.CoverageEstimate, error) {
+	var estimate nodes.CoverageEstimate
+	reqURL := fmt.Sprintf("%s%s%s/%s/coverage-estimate", api.Configuration.URL, APIPath, APIEnvironments, env)
+	if len(filter) > 0 {
+		reqURL += "?" + filter.Encode()
+	}
+	rawEstimate, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return estimate, fmt.Errorf("error api request - %v - %s", err, string(rawEstimate))
+	}
+	if err := json.Unmarshal(rawEstimate, &estimate); err != nil {
+		return estimate, fmt.Errorf("can not parse body - %v", err)
+	}
+	return estimate, nil
+}
+
+// GetEnrollLoops to get the hosts of an environment enrolling in a loop
+func (api *OsctrlAPI) GetEnrollLoops(env string) (nodes.EnrollLoopReport, error) {
+	var report nodes.EnrollLoopReport
+	reqURL := fmt.Sprintf("%s%s%s/%s/enroll-loops", api.Configuration.URL, APIPath, APIEnvironments, env)
+	rawReport, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return report, fmt.Errorf("error api request - %v - %s", err, string(rawReport))
+	}
+	if err := json.Unmarshal(rawReport, &report); err != nil {
+		return report, fmt.Errorf("can not parse body - %v", err)
+	}
+	return report, nil
+}
+
+// SetQueryExpiration to set the default expiration in hours of the queries of an environment
+func (api *OsctrlAPI) SetQueryExpiration(env string, hours int) (queries.ExpirationRange, error) {
+	var expiration queries.ExpirationRange
+	reqURL := fmt.Sprintf("%s%s%s/%s/query-expiration", api.Configuration.URL, APIPath, APIEnvironments, env)
+	jsonMessage, err := json.Marshal(types.ApiQueryExpirationRequest{Hours: hours})
+	if err != nil {
+		log.Printf("error marshaling data %s", err)
+	}
+	jsonParam := strings.NewReader(string(jsonMessage))
+	rawExpiration, err := api.PostGeneric(reqURL, jsonParam)
+	if err != nil {
+		return expiration, fmt.Errorf("error api request - %v - %s", err, string(rawExpiration))
+	}
+	if err := json.Unmarshal(rawExpiration, &expiration); err != nil {
+		return expiration, fmt.Errorf("can not parse body - %v", err)
+	}
+	return expiration, nil
+}
+
+// SetPseudonyms to set the columns and tables pseudonymized in the logs sent by an environment
+func (api *OsctrlAPI) SetPseudonyms(env, columns, tables string) (environments.PseudonymConfig, error) {
+	var cfg environments.PseudonymConfig
+	reqURL := fmt.Sprintf("%s%s%s/%s/pseudonyms", api.Configuration.URL, APIPath, APIEnvironments, env)
+	jsonMessage, err := json.Marshal(types.ApiPseudonymsRequest{Columns: columns, Tables: tables})
+	if err != nil {
+		log.Printf("error marshaling data %s", err)
+	}
+	jsonParam := strings.NewReader(string(jsonMessage))
+	rawCfg, err := api.PostGeneric(reqURL, jsonParam)
+	if err != nil {
+		return cfg, fmt.Errorf("error api request - %v - %s", err, string(rawCfg))
+	}
+	if err := json.Unmarshal(rawCfg, &cfg); err != nil {
+		return cfg, fmt.Errorf("can not parse body - %v", err)
+	}
+	return cfg, nil
+}
+
+// LookupPseudonym to re-identify a pseudonym found in the logs of an environment
+func (api *OsctrlAPI) LookupPseudonym(env, pseudonym string) (types.ApiPseudonymLookup, error) {
+	var lookup types.ApiPseudonymLookup
+	reqURL := fmt.Sprintf("%s%s%s/%s/pseudonyms/%s", api.Configuration.URL, APIPath, APIEnvironments, env, url.PathEscape(pseudonym))
+	rawLookup, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return lookup, fmt.Errorf("error api request - %v - %s", err, string(rawLookup))
+	}
+	if err := json.Unmarshal(rawLookup, &lookup); err != nil {
+		return lookup, fmt.Errorf("can not parse body - %v", err)
+	}
+	return lookup, nil
+}
+
+// GetQuickActions to retrieve the quick actions of an environment
+func (api *OsctrlAPI) GetQuickActions(env string) ([]queries.QuickAction, error) {
+	var actions []queries.QuickAction
+	reqURL := fmt.Sprintf("%s%s%s/%s/quick-actions", api.Configuration.URL, APIPath, APIEnvironments, env)
+	rawActions, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return actions, fmt.Errorf("error api request - %v - %s", err, string(rawActions))
+	}
+	if err := json.Unmarshal(rawActions, &actions); err != nil {
+		return actions, fmt.Errorf("can not parse body - %v", err)
+	}
+	return actions, nil
+}
+
+// SetQuickAction to create or update a quick action of an environment
+func (api *OsctrlAPI) SetQuickAction(env string, q types.ApiQuickActionRequest) (queries.QuickAction, error) {
+	var action queries.QuickAction
+	reqURL := fmt.Sprintf("%s%s%s/%s/quick-actions", api.Configuration.URL, APIPath, APIEnvironments, env)
+	jsonMessage, err := json.Marshal(q)
+	if err != nil {
+		log.Printf("error marshaling data %s", err)
+	}
+	jsonParam := strings.NewReader(string(jsonMessage))
+	rawAction, err := api.PostGeneric(reqURL, jsonParam)
+	if err != nil {
+		return action, fmt.Errorf("error api request - %v - %s", err, string(rawAction))
+	}
+	if err := json.Unmarshal(rawAction, &action); err != nil {
+		return action, fmt.Errorf("can not parse body - %v", err)
+	}
+	return action, nil
+}
+
+// DeleteQuickAction to delete a quick action of an environment
+func (api *OsctrlAPI) DeleteQuickAction(env, name string) (types.ApiGenericResponse, error) {
+	var r types.ApiGenericResponse
+	reqURL := fmt.Sprintf("%s%s%s/%s/quick-actions/%s/delete", api.Configuration.URL, APIPath, APIEnvironments, env, url.PathEscape(name))
+	rawR, err := api.PostGeneric(reqURL, nil)
+	if err != nil {
+		return r, fmt.Errorf("error api request - %v - %s", err, string(rawR))
+	}
+	if err := json.Unmarshal(rawR, &r); err != nil {
+		return r, fmt.Errorf("can not parse body - %v", err)
+	}
+	return r, nil
+}
+
+// RunQuickAction to run a quick action against nodes of an environment, or preview what it would run
+func (api *OsctrlAPI) RunQuickAction(env, name string, q types.ApiQuickActionRunRequest) (types.ApiQuickActionRunResponse, error) {
+	var r types.ApiQuickActionRunResponse
+	reqURL := fmt.Sprintf("%s%s%s/%s/quick-actions/%s/run", api.Configuration.URL, APIPath, APIEnvironments, env, url.PathEscape(name))
+	jsonMessage, err := json.Marshal(q)
+	if err != nil {
+		log.Printf("error marshaling data %s", err)
+	}
+	jsonParam := strings.NewReader(string(jsonMessage))
+	rawR, err := api.PostGeneric(reqURL, jsonParam)
+	if err != nil {
+		return r, fmt.Errorf("error api request - %v - %s", err, string(rawR))
+	}
+	if err := json.Unmarshal(rawR, &r); err != nil {
+		return r, fmt.Errorf("can not parse body - %v", err)
+	}
+	return r, nil
+}
+
+// GetPackSource to retrieve the pack source of an environment with its synced packs
+func (api *OsctrlAPI) GetPackSource(env string) (environments.PackSyncStatus, error) {
+	var status environments.PackSyncStatus
+	reqURL := fmt.Sprintf("%s%s%s/%s/packs/source", api.Configuration.URL, APIPath, APIEnvironments, env)
+	rawStatus, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return status, fmt.Errorf("error api request - %v - %s", err, string(rawStatus))
+	}
+	if err := json.Unmarshal(rawStatus, &status); err != nil {
+		return status, fmt.Errorf("can not parse body - %v", err)
+	}
+	return status, nil
+}
+
+// SetPackSource to create or update the pack source of an environment
+func (api *OsctrlAPI) SetPackSource(env string, s types.ApiPackSourceRequest) (environments.PackSource, error) {
+	var source environments.PackSource
+	reqURL := fmt.Sprintf("%s%s%s/%s/packs/source", api.Configuration.URL, APIPath, APIEnvironments, env)
+	jsonMessage, err := json.Marshal(s)
+	if err != nil {
+		log.Printf("error marshaling data %s", err)
+	}
+	jsonParam := strings.NewReader(string(jsonMessage))
+	rawSource, err := api.PostGeneric(reqURL, jsonParam)
+	if err != nil {
+		return source, fmt.Errorf("error api request - %v - %s", err, string(rawSource))
+	}
+	if err := json.Unmarshal(rawSource, &source); err != nil {
+		return source, fmt.Errorf("can not parse body - %v", err)
+	}
+	return source, nil
+}
+
+// PackSourceAction to sync or delete the pack source of an environment, or resolve the conflict of a pack
+func (api *OsctrlAPI) PackSourceAction(env, action string, body interface{}) (types.ApiGenericResponse, error) {
+	var r types.ApiGenericResponse
+	reqURL := fmt.Sprintf("%s%s%s/%s/packs/source/%s", api.Configuration.URL, APIPath, APIEnvironments, env, action)
+	jsonMessage, err := json.Marshal(body)
+	if err != nil {
+		log.Printf("error marshaling data %s", err)
+	}
+	jsonParam := strings.NewReader(string(jsonMessage))
+	rawR, err := api.PostGeneric(reqURL, jsonParam)
+	if err != nil {
+		return r, fmt.Errorf("error api request - %v - %s", err, string(rawR))
+	}
+	if err := json.Unmarshal(rawR, &r); err != nil {
+		return r, fmt.Errorf("can not parse body - %v", err)
+	}
+	return r, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+
+	"github.com/jmpsec/osctrl/logging"
+	"github.com/jmpsec/osctrl/types"
+)
+
+// GetDeadLetters to retrieve the counts by reason and the latest dead letters, filtered by backend, reason, env and limit
+func (api *OsctrlAPI) GetDeadLetters(filter url.Values) (logging.DeadLetterReport, error) {
+	var report logging.DeadLetterReport
+	reqURL := fmt.Sprintf("%s%s%s/dlq", api.Configuration.URL, APIPath, APILogs)
+	if len(filter) > 0 {
+		reqURL += "?" + filter.Encode()
+	}
+	rawReport, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return report, fmt.Errorf("error api request - %v - %s", err, string(rawReport))
+	}
+	if err := json.Unmarshal(rawReport, &report); err != nil {
+		return report, fmt.Errorf("can not parse body - %v", err)
+	}
+	return report, nil
+}
+
+// ReplayDeadLetters to replay dead letters through osctrl-tls
+func (api *OsctrlAPI) ReplayDeadLetters(req types.ApiDeadLetterRequest) (types.ApiGenericResponse, error) {
+	return api.postDeadLetters("replay", req)
+}
+
+// PurgeDeadLetters to purge dead letters
+func (api *OsctrlAPI) PurgeDeadLetters(req types.ApiDeadLetterRequest) (types.ApiGenericResponse, error) {
+	return api.postDeadLetters("purge", req)
+}
+
+// Helper to send one action for dead letters
+func (api *OsctrlAPI) postDeadLetters(action string, req types.ApiDeadLetterRequest) (types.ApiGenericResponse, error) {
+	var r types.ApiGenericResponse
+	reqURL := fmt.Sprintf("%s%s%s/dlq/%s", api.Configuration.URL, APIPath, APILogs, action)
+	jsonMessage, err := json.Marshal(req)
+	if err != nil {
+		log.Printf("error marshaling data %s", err)
+	}
+	jsonParam := strings.NewReader(string(jsonMessage))
+	rawR, err := api.PostGeneric(reqURL, jsonParam)
+	if err != nil {
+		return r, fmt.Errorf("error api request - %v - %s", err, string(rawR))
+	}
+	if err := json.Unmarshal(rawR, &r); err != nil {
+		return r, fmt.Errorf("can not parse body - %v", err)
+	}
+	return r, nil
+}
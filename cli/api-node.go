@@ -4,16 +4,20 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"net/url"
 	"strings"
 
 	"github.com/jmpsec/osctrl/nodes"
 	"github.com/jmpsec/osctrl/types"
 )
 
-// GetNodes to retrieve nodes from osctrl
-func (api *OsctrlAPI) GetNodes(env, target string) ([]nodes.OsqueryNode, error) {
+// GetNodes to retrieve nodes from osctrl, filtered by enroll metadata as name:value
+func (api *OsctrlAPI) GetNodes(env, target string, meta []string) ([]nodes.OsqueryNode, error) {
 	var nds []nodes.OsqueryNode
 	reqURL := fmt.Sprintf("%s%s%s/%s/%s", api.Configuration.URL, APIPath, APINodes, env, target)
+	if len(meta) > 0 {
+		reqURL += "?" + url.Values{"meta": meta}.Encode()
+	}
 	rawNodes, err := api.GetGeneric(reqURL, nil)
 	if err != nil {
 		return nds, fmt.Errorf("error api request - %v - %s", err, string(rawNodes))
@@ -24,6 +28,20 @@ func (api *OsctrlAPI) GetNodes(env, target string) ([]nodes.OsqueryNode, error)
 	return nds, nil
 }
 
+// SearchNodes to find nodes in all the environments with access by the start of an identifier
+func (api *OsctrlAPI) SearchNodes(term string) ([]nodes.NodeMatch, error) {
+	var matches []nodes.NodeMatch
+	reqURL := fmt.Sprintf("%s%s%s/search?%s", api.Configuration.URL, APIPath, APINodes, url.Values{"q": []string{term}}.Encode())
+	rawMatches, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return matches, fmt.Errorf("error api request - %v - %s", err, string(rawMatches))
+	}
+	if err := json.Unmarshal(rawMatches, &matches); err != nil {
+		return matches, fmt.Errorf("can not parse body - %v", err)
+	}
+	return matches, nil
+}
+
 // GetNode to retrieve one node from osctrl
 func (api *OsctrlAPI) GetNode(env, identifier string) (nodes.OsqueryNode, error) {
 	var node nodes.OsqueryNode
@@ -38,6 +56,48 @@ func (api *OsctrlAPI) GetNode(env, identifier string) (nodes.OsqueryNode, error)
 	return node, nil
 }
 
+// GetNodeNetworkHistory to retrieve the network history of one node from osctrl
+func (api *OsctrlAPI) GetNodeNetworkHistory(env, identifier string) ([]nodes.NodeHistoryIPAddress, error) {
+	var history []nodes.NodeHistoryIPAddress
+	reqURL := fmt.Sprintf("%s%s%s/%s/%s/network-history", api.Configuration.URL, APIPath, APINodes, env, identifier)
+	rawHistory, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return history, fmt.Errorf("error api request - %v - %s", err, string(rawHistory))
+	}
+	if err := json.Unmarshal(rawHistory, &history); err != nil {
+		return history, fmt.Errorf("can not parse body - %v", err)
+	}
+	return history, nil
+}
+
+// GetNodeMembership to retrieve the environments and tags of one node at one point in time from osctrl
+func (api *OsctrlAPI) GetNodeMembership(env, identifier, at string) (nodes.MembershipState, error) {
+	var state nodes.MembershipState
+	reqURL := fmt.Sprintf("%s%s%s/%s/%s/membership?at=%s", api.Configuration.URL, APIPath, APINodes, env, identifier, url.QueryEscape(at))
+	rawState, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return state, fmt.Errorf("error api request - %v - %s", err, string(rawState))
+	}
+	if err := json.Unmarshal(rawState, &state); err != nil {
+		return state, fmt.Errorf("can not parse body - %v", err)
+	}
+	return state, nil
+}
+
+// GetNodeMembershipChanges to retrieve the changes of environments and tags of one node within a window from osctrl
+func (api *OsctrlAPI) GetNodeMembershipChanges(env, identifier, from, to string) ([]nodes.NodeMembership, error) {
+	var changes []nodes.NodeMembership
+	reqURL := fmt.Sprintf("%s%s%s/%s/%s/membership/changes?from=%s&to=%s", api.Configuration.URL, APIPath, APINodes, env, identifier, url.QueryEscape(from), url.QueryEscape(to))
+	rawChanges, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return changes, fmt.Errorf("error api request - %v - %s", err, string(rawChanges))
+	}
+	if err := json.Unmarshal(rawChanges, &changes); err != nil {
+		return changes, fmt.Errorf("can not parse body - %v", err)
+	}
+	return changes, nil
+}
+
 // DeleteNode to delete node from osctrl
 func (api *OsctrlAPI) DeleteNode(env, identifier string) error {
 	n := types.ApiNodeGenericRequest{
@@ -64,3 +124,163 @@ func (api *OsctrlAPI) DeleteNode(env, identifier string) error {
 func (api *OsctrlAPI) TagNode(env, identifier, tag string) error {
 	return nil
 }
+
+// DecommissionNode to decommission node in osctrl
+func (api *OsctrlAPI) DecommissionNode(env, identifier string) (nodes.NodeDecommission, error) {
+	n := types.ApiNodeGenericRequest{
+		UUID: identifier,
+	}
+	var d nodes.NodeDecommission
+	reqURL := fmt.Sprintf("%s%s%s/%s/decommission", api.Configuration.URL, APIPath, APINodes, env)
+	jsonMessage, err := json.Marshal(n)
+	if err != nil {
+		log.Printf("error marshaling data %s", err)
+	}
+	jsonParam := strings.NewReader(string(jsonMessage))
+	rawD, err := api.PostGeneric(reqURL, jsonParam)
+	if err != nil {
+		return d, fmt.Errorf("error api request - %v - %s", err, string(rawD))
+	}
+	if err := json.Unmarshal(rawD, &d); err != nil {
+		return d, fmt.Errorf("can not parse body - %v", err)
+	}
+	return d, nil
+}
+
+// PauseNode to pause node in osctrl, with the reason and expiration
+func (api *OsctrlAPI) PauseNode(env, identifier, reason, expires string) (nodes.NodePause, error) {
+	p := types.ApiNodePauseRequest{
+		Reason:    reason,
+		ExpiresAt: expires,
+	}
+	var pause nodes.NodePause
+	reqURL := fmt.Sprintf("%s%s%s/%s/%s/pause", api.Configuration.URL, APIPath, APINodes, env, identifier)
+	jsonMessage, err := json.Marshal(p)
+	if err != nil {
+		log.Printf("error marshaling data %s", err)
+	}
+	jsonParam := strings.NewReader(string(jsonMessage))
+	rawP, err := api.PostGeneric(reqURL, jsonParam)
+	if err != nil {
+		return pause, fmt.Errorf("error api request - %v - %s", err, string(rawP))
+	}
+	if err := json.Unmarshal(rawP, &pause); err != nil {
+		return pause, fmt.Errorf("can not parse body - %v", err)
+	}
+	return pause, nil
+}
+
+// UnpauseNode to unpause node in osctrl
+func (api *OsctrlAPI) UnpauseNode(env, identifier string) (nodes.NodePause, error) {
+	var pause nodes.NodePause
+	reqURL := fmt.Sprintf("%s%s%s/%s/%s/unpause", api.Configuration.URL, APIPath, APINodes, env, identifier)
+	rawP, err := api.PostGeneric(reqURL, nil)
+	if err != nil {
+		return pause, fmt.Errorf("error api request - %v - %s", err, string(rawP))
+	}
+	if err := json.Unmarshal(rawP, &pause); err != nil {
+		return pause, fmt.Errorf("can not parse body - %v", err)
+	}
+	return pause, nil
+}
+
+// GetDecommissions to retrieve decommissioned nodes by state from osctrl
+func (api *OsctrlAPI) GetDecommissions(env, state string) ([]nodes.NodeDecommission, error) {
+	var ds []nodes.NodeDecommission
+	reqURL := fmt.Sprintf("%s%s%s/%s/decommissions", api.Configuration.URL, APIPath, APINodes, env)
+	if state != "" {
+		reqURL += "?state=" + url.QueryEscape(state)
+	}
+	rawDs, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return ds, fmt.Errorf("error api request - %v - %s", err, string(rawDs))
+	}
+	if err := json.Unmarshal(rawDs, &ds); err != nil {
+		return ds, fmt.Errorf("can not parse body - %v", err)
+	}
+	return ds, nil
+}
+
+// MergeNode to merge a duplicate node into the canonical node in osctrl
+func (api *OsctrlAPI) MergeNode(env, into, from string) (nodes.NodeMerge, error) {
+	m := types.ApiNodeMergeRequest{
+		Into: into,
+		From: from,
+	}
+	var merge nodes.NodeMerge
+	reqURL := fmt.Sprintf("%s%s%s/%s/merge", api.Configuration.URL, APIPath, APINodes, env)
+	jsonMessage, err := json.Marshal(m)
+	if err != nil {
+		log.Printf("error marshaling data %s", err)
+	}
+	jsonParam := strings.NewReader(string(jsonMessage))
+	rawM, err := api.PostGeneric(reqURL, jsonParam)
+	if err != nil {
+		return merge, fmt.Errorf("error api request - %v - %s", err, string(rawM))
+	}
+	if err := json.Unmarshal(rawM, &merge); err != nil {
+		return merge, fmt.Errorf("can not parse body - %v", err)
+	}
+	return merge, nil
+}
+
+// GetMerges to retrieve merges of duplicate nodes by state from osctrl
+func (api *OsctrlAPI) GetMerges(env, state string) ([]nodes.NodeMerge, error) {
+	var ms []nodes.NodeMerge
+	reqURL := fmt.Sprintf("%s%s%s/%s/merges", api.Configuration.URL, APIPath, APINodes, env)
+	if state != "" {
+		reqURL += "?state=" + url.QueryEscape(state)
+	}
+	rawMs, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return ms, fmt.Errorf("error api request - %v - %s", err, string(rawMs))
+	}
+	if err := json.Unmarshal(rawMs, &ms); err != nil {
+		return ms, fmt.Errorf("can not parse body - %v", err)
+	}
+	return ms, nil
+}
+
+// ReviewMerge to approve, reject or split a merge of duplicate nodes in osctrl
+func (api *OsctrlAPI) ReviewMerge(env string, id uint, action string) (nodes.NodeMerge, error) {
+	m := types.ApiNodeMergeReviewRequest{
+		Action: action,
+	}
+	var merge nodes.NodeMerge
+	reqURL := fmt.Sprintf("%s%s%s/%s/merges/%d", api.Configuration.URL, APIPath, APINodes, env, id)
+	jsonMessage, err := json.Marshal(m)
+	if err != nil {
+		log.Printf("error marshaling data %s", err)
+	}
+	jsonParam := strings.NewReader(string(jsonMessage))
+	rawM, err := api.PostGeneric(reqURL, jsonParam)
+	if err != nil {
+		return merge, fmt.Errorf("error api request - %v - %s", err, string(rawM))
+	}
+	if err := json.Unmarshal(rawM, &merge); err != nil {
+		return merge, fmt.Errorf("can not parse body - %v", err)
+	}
+	return merge, nil
+}
+
+// StatusTokenNode to generate a status token for a node in osctrl, to share its status with help desk
+func (api *OsctrlAPI) StatusTokenNode(env, identifier string, hours int64) (types.ApiStatusTokenResponse, error) {
+	t := types.ApiStatusTokenRequest{
+		Hours: hours,
+	}
+	var s types.ApiStatusTokenResponse
+	reqURL := fmt.Sprintf("%s%s%s/%s/%s/status-token", api.Configuration.URL, APIPath, APINodes, env, identifier)
+	jsonMessage, err := json.Marshal(t)
+	if err != nil {
+		log.Printf("error marshaling data %s", err)
+	}
+	jsonParam := strings.NewReader(string(jsonMessage))
+	rawS, err := api.PostGeneric(reqURL, jsonParam)
+	if err != nil {
+		return s, fmt.Errorf("error api request - %v - %s", err, string(rawS))
+	}
+	if err := json.Unmarshal(rawS, &s); err != nil {
+		return s, fmt.Errorf("can not parse body - %v", err)
+	}
+	return s, nil
+}
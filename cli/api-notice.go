@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/users"
+)
+
+// GetNotices to retrieve the active notices from osctrl, only the ones shown in an environment if env is not empty
+// and with the expired and removed ones if history is true
+func (api *OsctrlAPI) GetNotices(env string, history bool) ([]users.Notice, error) {
+	var ns []users.Notice
+	filter := url.Values{}
+	if env != "" {
+		filter.Set("env", env)
+	}
+	if history {
+		filter.Set("history", "true")
+	}
+	reqURL := fmt.Sprintf("%s%s%s", api.Configuration.URL, APIPath, APINotices)
+	if len(filter) > 0 {
+		reqURL += "?" + filter.Encode()
+	}
+	rawNs, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return ns, fmt.Errorf("error api request - %v - %s", err, string(rawNs))
+	}
+	if err := json.Unmarshal(rawNs, &ns); err != nil {
+		return ns, fmt.Errorf("can not parse body - %v", err)
+	}
+	return ns, nil
+}
+
+// SaveNotice to create a notice in osctrl, or to update it if id is not zero
+func (api *OsctrlAPI) SaveNotice(id uint, nr types.ApiNoticeRequest) (users.Notice, error) {
+	var n users.Notice
+	reqURL := fmt.Sprintf("%s%s%s", api.Configuration.URL, APIPath, APINotices)
+	if id != 0 {
+		reqURL = fmt.Sprintf("%s/%d", reqURL, id)
+	}
+	jsonMessage, err := json.Marshal(nr)
+	if err != nil {
+		log.Printf("error marshaling data %s", err)
+	}
+	jsonParam := strings.NewReader(string(jsonMessage))
+	rawN, err := api.PostGeneric(reqURL, jsonParam)
+	if err != nil {
+		return n, fmt.Errorf("error api request - %v - %s", err, string(rawN))
+	}
+	if err := json.Unmarshal(rawN, &n); err != nil {
+		return n, fmt.Errorf("can not parse body - %v", err)
+	}
+	return n, nil
+}
+
+// DeleteNotice to remove a notice from osctrl
+func (api *OsctrlAPI) DeleteNotice(id uint) error {
+	var r types.ApiGenericResponse
+	reqURL := fmt.Sprintf("%s%s%s/%d/delete", api.Configuration.URL, APIPath, APINotices, id)
+	rawR, err := api.PostGeneric(reqURL, nil)
+	if err != nil {
+		return fmt.Errorf("error api request - %v - %s", err, string(rawR))
+	}
+	if err := json.Unmarshal(rawR, &r); err != nil {
+		return fmt.Errorf("can not parse body - %v", err)
+	}
+	return nil
+}
@@ -3,17 +3,20 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
+	"net/url"
 	"strings"
 
 	"github.com/jmpsec/osctrl/queries"
 	"github.com/jmpsec/osctrl/types"
 )
 
-// GetQueries to retrieve queries from osctrl
-func (api *OsctrlAPI) GetQueries(env string) ([]queries.DistributedQuery, error) {
+// GetQueries to retrieve queries from osctrl, filtered by label and ticket
+func (api *OsctrlAPI) GetQueries(env, label, ticket string) ([]queries.DistributedQuery, error) {
 	var qs []queries.DistributedQuery
-	reqURL := fmt.Sprintf("%s%s%s/%s", api.Configuration.URL, APIPath, APIQueries, env)
+	reqURL := fmt.Sprintf("%s%s%s/%s?%s", api.Configuration.URL, APIPath, APIQueries, env, url.Values{"label": {label}, "ticket": {ticket}}.Encode())
 	rawQs, err := api.GetGeneric(reqURL, nil)
 	if err != nil {
 		return qs, fmt.Errorf("error api request - %v - %s", err, string(rawQs))
@@ -38,23 +41,44 @@ func (api *OsctrlAPI) GetQuery(env, name string) (queries.DistributedQuery, erro
 	return q, nil
 }
 
-// DeleteQuery to delete query from osctrl
-func (api *OsctrlAPI) DeleteQuery(env, identifier string) error {
+// DeleteQuery to move a query to the trash of osctrl, or delete it permanently
+func (api *OsctrlAPI) DeleteQuery(env, identifier string, hard bool) error {
+	var r types.ApiGenericResponse
+	reqURL := fmt.Sprintf("%s%s%s/%s/%s/delete", api.Configuration.URL, APIPath, APIQueries, env, identifier)
+	if hard {
+		reqURL += "?hard=true"
+	}
+	rawR, err := api.PostGeneric(reqURL, nil)
+	if err != nil {
+		return fmt.Errorf("error api request - %v - %s", err, string(rawR))
+	}
+	if err := json.Unmarshal(rawR, &r); err != nil {
+		return fmt.Errorf("can not parse body - %v", err)
+	}
 	return nil
 }
 
+// GetQueryResults to retrieve the results of a query by node from osctrl
+func (api *OsctrlAPI) GetQueryResults(env, name string) (map[string]json.RawMessage, error) {
+	var r map[string]json.RawMessage
+	reqURL := fmt.Sprintf("%s%s%s/%s/results/%s", api.Configuration.URL, APIPath, APIQueries, env, name)
+	rawR, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return r, fmt.Errorf("error api request - %v - %s", err, string(rawR))
+	}
+	if err := json.Unmarshal(rawR, &r); err != nil {
+		return r, fmt.Errorf("can not parse body - %v", err)
+	}
+	return r, nil
+}
+
 // CompleteQuery to complete a query from osctrl
 func (api *OsctrlAPI) CompleteQuery(env, identifier string) error {
 	return nil
 }
 
 // RunQuery to initiate a query in osctrl
-func (api *OsctrlAPI) RunQuery(env, uuid, query string, hidden bool) (types.ApiQueriesResponse, error) {
-	q := types.ApiDistributedQueryRequest{
-		UUID:   uuid,
-		Query:  query,
-		Hidden: hidden,
-	}
+func (api *OsctrlAPI) RunQuery(env string, q types.ApiDistributedQueryRequest) (types.ApiQueriesResponse, error) {
 	var r types.ApiQueriesResponse
 	reqURL := fmt.Sprintf("%s%s%s/%s", api.Configuration.URL, APIPath, APIQueries, env)
 	jsonMessage, err := json.Marshal(q)
@@ -71,3 +95,252 @@ func (api *OsctrlAPI) RunQuery(env, uuid, query string, hidden bool) (types.ApiQ
 	}
 	return r, nil
 }
+
+// GetQueryGroups to retrieve the concurrency groups of queries from osctrl
+func (api *OsctrlAPI) GetQueryGroups(env string) ([]queries.GroupUtilization, error) {
+	var groups []queries.GroupUtilization
+	reqURL := fmt.Sprintf("%s%s%s/%s/groups", api.Configuration.URL, APIPath, APIQueries, env)
+	rawG, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return groups, fmt.Errorf("error api request - %v - %s", err, string(rawG))
+	}
+	if err := json.Unmarshal(rawG, &groups); err != nil {
+		return groups, fmt.Errorf("can not parse body - %v", err)
+	}
+	return groups, nil
+}
+
+// GetQueryArchive to retrieve the archive of a query from osctrl
+func (api *OsctrlAPI) GetQueryArchive(env, name string) (queries.QueryArchive, error) {
+	var a queries.QueryArchive
+	reqURL := fmt.Sprintf("%s%s%s/%s/%s/archive", api.Configuration.URL, APIPath, APIQueries, env, name)
+	rawA, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return a, fmt.Errorf("error api request - %v - %s", err, string(rawA))
+	}
+	if err := json.Unmarshal(rawA, &a); err != nil {
+		return a, fmt.Errorf("can not parse body - %v", err)
+	}
+	return a, nil
+}
+
+// RehydrateQuery to request the results of an archived query to be restored in osctrl
+func (api *OsctrlAPI) RehydrateQuery(env, name string) (queries.QueryArchive, error) {
+	var a queries.QueryArchive
+	reqURL := fmt.Sprintf("%s%s%s/%s/%s/rehydrate", api.Configuration.URL, APIPath, APIQueries, env, name)
+	rawA, err := api.PostGeneric(reqURL, nil)
+	if err != nil {
+		return a, fmt.Errorf("error api request - %v - %s", err, string(rawA))
+	}
+	if err := json.Unmarshal(rawA, &a); err != nil {
+		return a, fmt.Errorf("can not parse body - %v", err)
+	}
+	return a, nil
+}
+
+// SetQueryGroupLimits to set the concurrency limits of a group of queries in osctrl
+func (api *OsctrlAPI) SetQueryGroupLimits(env string, g types.ApiQueryGroupRequest) error {
+	reqURL := fmt.Sprintf("%s%s%s/%s/groups", api.Configuration.URL, APIPath, APIQueries, env)
+	jsonMessage, err := json.Marshal(g)
+	if err != nil {
+		return fmt.Errorf("error marshaling data - %v", err)
+	}
+	rawR, err := api.PostGeneric(reqURL, strings.NewReader(string(jsonMessage)))
+	if err != nil {
+		return fmt.Errorf("error api request - %v - %s", err, string(rawR))
+	}
+	return nil
+}
+
+// GetQueryPolicies to retrieve the query policies of an environment from osctrl
+func (api *OsctrlAPI) GetQueryPolicies(env string) ([]queries.QueryPolicy, error) {
+	var ps []queries.QueryPolicy
+	reqURL := fmt.Sprintf("%s%s%s/%s/policies", api.Configuration.URL, APIPath, APIQueries, env)
+	rawPs, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return ps, fmt.Errorf("error api request - %v - %s", err, string(rawPs))
+	}
+	if err := json.Unmarshal(rawPs, &ps); err != nil {
+		return ps, fmt.Errorf("can not parse body - %v", err)
+	}
+	return ps, nil
+}
+
+// SetQueryPolicy to set the query policy of a subject in osctrl
+func (api *OsctrlAPI) SetQueryPolicy(env string, p types.ApiQueryPolicyRequest) error {
+	reqURL := fmt.Sprintf("%s%s%s/%s/policies", api.Configuration.URL, APIPath, APIQueries, env)
+	jsonMessage, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("error marshaling data - %v", err)
+	}
+	rawR, err := api.PostGeneric(reqURL, strings.NewReader(string(jsonMessage)))
+	if err != nil {
+		return fmt.Errorf("error api request - %v - %s", err, string(rawR))
+	}
+	return nil
+}
+
+// DeleteQueryPolicy to delete the query policy of a subject in osctrl
+func (api *OsctrlAPI) DeleteQueryPolicy(env, subject string) error {
+	reqURL := fmt.Sprintf("%s%s%s/%s/policies/delete", api.Configuration.URL, APIPath, APIQueries, env)
+	jsonMessage, err := json.Marshal(types.ApiQueryPolicyRequest{Subject: subject})
+	if err != nil {
+		return fmt.Errorf("error marshaling data - %v", err)
+	}
+	rawR, err := api.PostGeneric(reqURL, strings.NewReader(string(jsonMessage)))
+	if err != nil {
+		return fmt.Errorf("error api request - %v - %s", err, string(rawR))
+	}
+	return nil
+}
+
+// SetQueryContext to replace the investigation context of a query in osctrl
+func (api *OsctrlAPI) SetQueryContext(env, name string, c queries.QueryContext) error {
+	reqURL := fmt.Sprintf("%s%s%s/%s/%s/context", api.Configuration.URL, APIPath, APIQueries, env, name)
+	jsonMessage, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("error marshaling data - %v", err)
+	}
+	rawR, err := api.PostGeneric(reqURL, strings.NewReader(string(jsonMessage)))
+	if err != nil {
+		return fmt.Errorf("error api request - %v - %s", err, string(rawR))
+	}
+	return nil
+}
+
+// GetQueryProfile to retrieve the report of a profile query from osctrl
+func (api *OsctrlAPI) GetQueryProfile(env, name string) (queries.ProfileReport, error) {
+	var report queries.ProfileReport
+	reqURL := fmt.Sprintf("%s%s%s/%s/profile/%s", api.Configuration.URL, APIPath, APIQueries, env, name)
+	rawR, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return report, fmt.Errorf("error api request - %v - %s", err, string(rawR))
+	}
+	if err := json.Unmarshal(rawR, &report); err != nil {
+		return report, fmt.Errorf("can not parse body - %v", err)
+	}
+	return report, nil
+}
+
+// LaunchQueryProfile to launch in all targets the query of a profile query in osctrl
+func (api *OsctrlAPI) LaunchQueryProfile(env, name string) (types.ApiQueriesResponse, error) {
+	var r types.ApiQueriesResponse
+	reqURL := fmt.Sprintf("%s%s%s/%s/profile/%s/launch", api.Configuration.URL, APIPath, APIQueries, env, name)
+	rawQ, err := api.PostGeneric(reqURL, strings.NewReader("{}"))
+	if err != nil {
+		return r, fmt.Errorf("error api request - %v - %s", err, string(rawQ))
+	}
+	if err := json.Unmarshal(rawQ, &r); err != nil {
+		return r, fmt.Errorf("can not parse body - %v", err)
+	}
+	return r, nil
+}
+
+// ShareQueries to request a sharing export of queries in osctrl, it is generated asynchronously
+func (api *OsctrlAPI) ShareQueries(env string, s types.ApiShareRequest) (queries.ShareExport, error) {
+	var share queries.ShareExport
+	reqURL := fmt.Sprintf("%s%s%s/%s", api.Configuration.URL, APIPath, APIShares, env)
+	jsonMessage, err := json.Marshal(s)
+	if err != nil {
+		return share, fmt.Errorf("error marshaling data - %v", err)
+	}
+	rawS, err := api.PostGeneric(reqURL, strings.NewReader(string(jsonMessage)))
+	if err != nil {
+		return share, fmt.Errorf("error api request - %v - %s", err, string(rawS))
+	}
+	if err := json.Unmarshal(rawS, &share); err != nil {
+		return share, fmt.Errorf("can not parse body - %v", err)
+	}
+	return share, nil
+}
+
+// GetShare to retrieve the status of a sharing export from osctrl
+func (api *OsctrlAPI) GetShare(env, name string) (queries.ShareExport, error) {
+	var share queries.ShareExport
+	reqURL := fmt.Sprintf("%s%s%s/%s/%s", api.Configuration.URL, APIPath, APIShares, env, name)
+	rawS, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return share, fmt.Errorf("error api request - %v - %s", err, string(rawS))
+	}
+	if err := json.Unmarshal(rawS, &share); err != nil {
+		return share, fmt.Errorf("can not parse body - %v", err)
+	}
+	return share, nil
+}
+
+// DownloadShare to retrieve the ZIP of a sharing export from osctrl
+func (api *OsctrlAPI) DownloadShare(env, name string) ([]byte, error) {
+	reqURL := fmt.Sprintf("%s%s%s/%s/%s/download", api.Configuration.URL, APIPath, APIShares, env, name)
+	rawContent, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error api request - %v - %s", err, string(rawContent))
+	}
+	return rawContent, nil
+}
+
+// ExportQuery to request the results of a query in a format from osctrl. Small results are written to w and a nil job
+// is returned, large results are materialized by an export job that is returned instead.
+// With job true, an export job is always requested.
+func (api *OsctrlAPI) ExportQuery(env, name, format string, job bool, w io.Writer) (*queries.ExportJob, error) {
+	params := url.Values{"format": {format}}
+	if job {
+		params.Set("async", "true")
+	}
+	reqURL := fmt.Sprintf("%s%s%s/%s/%s/results?%s", api.Configuration.URL, APIPath, APIQueries, env, name, params.Encode())
+	resp, err := api.StreamGeneric(reqURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error api request - %v", err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if _, err := io.Copy(w, resp.Body); err != nil {
+			return nil, fmt.Errorf("error reading results - %v", err)
+		}
+		return nil, nil
+	case http.StatusAccepted:
+		var j queries.ExportJob
+		if err := json.NewDecoder(resp.Body).Decode(&j); err != nil {
+			return nil, fmt.Errorf("can not parse body - %v", err)
+		}
+		return &j, nil
+	}
+	body, _ := io.ReadAll(resp.Body)
+	return nil, fmt.Errorf("error api request - HTTP Code %d - %s", resp.StatusCode, string(body))
+}
+
+// GetExportJob to retrieve the status of an export job from osctrl
+func (api *OsctrlAPI) GetExportJob(env, jobID string) (queries.ExportJob, error) {
+	var j queries.ExportJob
+	reqURL := fmt.Sprintf("%s%s%s/%s/%s", api.Configuration.URL, APIPath, APIExports, env, jobID)
+	rawJ, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return j, fmt.Errorf("error api request - %v - %s", err, string(rawJ))
+	}
+	if err := json.Unmarshal(rawJ, &j); err != nil {
+		return j, fmt.Errorf("can not parse body - %v", err)
+	}
+	return j, nil
+}
+
+// DownloadExportRange to download the artifact of an export job from an offset to the end, returning the bytes written
+func (api *OsctrlAPI) DownloadExportRange(env, jobID string, offset int64, w io.Writer) (int64, error) {
+	reqURL := fmt.Sprintf("%s%s%s/%s/%s/download", api.Configuration.URL, APIPath, APIExports, env, jobID)
+	extra := map[string]string{}
+	if offset > 0 {
+		extra["Range"] = fmt.Sprintf("bytes=%d-", offset)
+	}
+	resp, err := api.StreamGeneric(reqURL, extra)
+	if err != nil {
+		return 0, fmt.Errorf("error api request - %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		body, _ := io.ReadAll(resp.Body)
+		return 0, fmt.Errorf("error api request - HTTP Code %d - %s", resp.StatusCode, string(body))
+	}
+	if offset > 0 && resp.StatusCode != http.StatusPartialContent {
+		return 0, fmt.Errorf("range requests are not supported")
+	}
+	return io.Copy(w, resp.Body)
+}
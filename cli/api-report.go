@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/jmpsec/osctrl/reports"
+	"github.com/jmpsec/osctrl/types"
+)
+
+// GetReports to retrieve generated reports from osctrl
+func (api *OsctrlAPI) GetReports() ([]reports.Report, error) {
+	var rs []reports.Report
+	reqURL := fmt.Sprintf("%s%s%s", api.Configuration.URL, APIPath, APIReports)
+	rawRs, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return rs, fmt.Errorf("error api request - %v - %s", err, string(rawRs))
+	}
+	if err := json.Unmarshal(rawRs, &rs); err != nil {
+		return rs, fmt.Errorf("can not parse body - %v", err)
+	}
+	return rs, nil
+}
+
+// GenerateReport to generate a report in osctrl, for all environments if env is empty
+func (api *OsctrlAPI) GenerateReport(env, category, period, format string) (reports.Report, error) {
+	rr := types.ApiReportRequest{
+		Environment: env,
+		Category:    category,
+		Period:      period,
+		Format:      format,
+	}
+	var r reports.Report
+	reqURL := fmt.Sprintf("%s%s%s", api.Configuration.URL, APIPath, APIReports)
+	jsonMessage, err := json.Marshal(rr)
+	if err != nil {
+		log.Printf("error marshaling data %s", err)
+	}
+	jsonParam := strings.NewReader(string(jsonMessage))
+	rawR, err := api.PostGeneric(reqURL, jsonParam)
+	if err != nil {
+		return r, fmt.Errorf("error api request - %v - %s", err, string(rawR))
+	}
+	if err := json.Unmarshal(rawR, &r); err != nil {
+		return r, fmt.Errorf("can not parse body - %v", err)
+	}
+	return r, nil
+}
+
+// DownloadReport to retrieve the content of a report from osctrl
+func (api *OsctrlAPI) DownloadReport(id uint) ([]byte, error) {
+	reqURL := fmt.Sprintf("%s%s%s/%d/download", api.Configuration.URL, APIPath, APIReports, id)
+	rawContent, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error api request - %v - %s", err, string(rawContent))
+	}
+	return rawContent, nil
+}
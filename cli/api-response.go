@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/jmpsec/osctrl/responses"
+	"github.com/jmpsec/osctrl/types"
+)
+
+// GetResponseActions to retrieve the latest response actions of an environment
+func (api *OsctrlAPI) GetResponseActions(env string, limit int) ([]responses.ResponseAction, error) {
+	var actions []responses.ResponseAction
+	reqURL := fmt.Sprintf("%s%s%s/%s?limit=%d", api.Configuration.URL, APIPath, APIResponses, env, limit)
+	rawActions, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return actions, fmt.Errorf("error api request - %v - %s", err, string(rawActions))
+	}
+	if err := json.Unmarshal(rawActions, &actions); err != nil {
+		return actions, fmt.Errorf("can not parse body - %v", err)
+	}
+	return actions, nil
+}
+
+// GetResponseConfig to retrieve the response actions configuration and triggers of an environment
+func (api *OsctrlAPI) GetResponseConfig(env string) (responses.EnvResponses, error) {
+	var current responses.EnvResponses
+	reqURL := fmt.Sprintf("%s%s%s/%s/config", api.Configuration.URL, APIPath, APIResponses, env)
+	rawCurrent, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return current, fmt.Errorf("error api request - %v - %s", err, string(rawCurrent))
+	}
+	if err := json.Unmarshal(rawCurrent, &current); err != nil {
+		return current, fmt.Errorf("can not parse body - %v", err)
+	}
+	return current, nil
+}
+
+// SetResponseConfig to save the response actions configuration of an environment, or reset its circuit breaker
+func (api *OsctrlAPI) SetResponseConfig(env string, c types.ApiResponseConfigRequest) (responses.EnvResponses, error) {
+	var current responses.EnvResponses
+	reqURL := fmt.Sprintf("%s%s%s/%s/config", api.Configuration.URL, APIPath, APIResponses, env)
+	jsonMessage, err := json.Marshal(c)
+	if err != nil {
+		log.Printf("error marshaling data %s", err)
+	}
+	jsonParam := strings.NewReader(string(jsonMessage))
+	rawCurrent, err := api.PostGeneric(reqURL, jsonParam)
+	if err != nil {
+		return current, fmt.Errorf("error api request - %v - %s", err, string(rawCurrent))
+	}
+	if err := json.Unmarshal(rawCurrent, &current); err != nil {
+		return current, fmt.Errorf("can not parse body - %v", err)
+	}
+	return current, nil
+}
+
+// SetResponseTrigger to create or update a response trigger of an environment
+func (api *OsctrlAPI) SetResponseTrigger(env string, t types.ApiResponseTriggerRequest) (responses.ResponseTrigger, error) {
+	var trigger responses.ResponseTrigger
+	reqURL := fmt.Sprintf("%s%s%s/%s/triggers", api.Configuration.URL, APIPath, APIResponses, env)
+	jsonMessage, err := json.Marshal(t)
+	if err != nil {
+		log.Printf("error marshaling data %s", err)
+	}
+	jsonParam := strings.NewReader(string(jsonMessage))
+	rawTrigger, err := api.PostGeneric(reqURL, jsonParam)
+	if err != nil {
+		return trigger, fmt.Errorf("error api request - %v - %s", err, string(rawTrigger))
+	}
+	if err := json.Unmarshal(rawTrigger, &trigger); err != nil {
+		return trigger, fmt.Errorf("can not parse body - %v", err)
+	}
+	return trigger, nil
+}
+
+// DeleteResponseTrigger to delete a response trigger of an environment
+func (api *OsctrlAPI) DeleteResponseTrigger(env, name string) (types.ApiGenericResponse, error) {
+	var r types.ApiGenericResponse
+	reqURL := fmt.Sprintf("%s%s%s/%s/triggers/delete", api.Configuration.URL, APIPath, APIResponses, env)
+	jsonMessage, err := json.Marshal(types.ApiResponseTriggerRequest{Name: name})
+	if err != nil {
+		log.Printf("error marshaling data %s", err)
+	}
+	jsonParam := strings.NewReader(string(jsonMessage))
+	rawR, err := api.PostGeneric(reqURL, jsonParam)
+	if err != nil {
+		return r, fmt.Errorf("error api request - %v - %s", err, string(rawR))
+	}
+	if err := json.Unmarshal(rawR, &r); err != nil {
+		return r, fmt.Errorf("can not parse body - %v", err)
+	}
+	return r, nil
+}
+
+// SendResponseAlert to fire a response trigger for one node with the fields of an external alert
+func (api *OsctrlAPI) SendResponseAlert(env string, a types.ApiResponseAlertRequest) (responses.ResponseAction, error) {
+	var action responses.ResponseAction
+	reqURL := fmt.Sprintf("%s%s%s/%s/alert", api.Configuration.URL, APIPath, APIResponses, env)
+	jsonMessage, err := json.Marshal(a)
+	if err != nil {
+		log.Printf("error marshaling data %s", err)
+	}
+	jsonParam := strings.NewReader(string(jsonMessage))
+	rawAction, err := api.PostGeneric(reqURL, jsonParam)
+	if err != nil {
+		return action, fmt.Errorf("error api request - %v - %s", err, string(rawAction))
+	}
+	if err := json.Unmarshal(rawAction, &action); err != nil {
+		return action, fmt.Errorf("can not parse body - %v", err)
+	}
+	return action, nil
+}
+
+// GetMutes to retrieve the mutes of an environment
+func (api *OsctrlAPI) GetMutes(env string) ([]responses.Mute, error) {
+	var mutes []responses.Mute
+	reqURL := fmt.Sprintf("%s%s%s/%s/mutes", api.Configuration.URL, APIPath, APIResponses, env)
+	rawMutes, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return mutes, fmt.Errorf("error api request - %v - %s", err, string(rawMutes))
+	}
+	if err := json.Unmarshal(rawMutes, &mutes); err != nil {
+		return mutes, fmt.Errorf("can not parse body - %v", err)
+	}
+	return mutes, nil
+}
+
+// SetMute to mute notifications for a node or for an environment
+func (api *OsctrlAPI) SetMute(env string, m types.ApiMuteRequest) (responses.Mute, error) {
+	var mute responses.Mute
+	reqURL := fmt.Sprintf("%s%s%s/%s/mutes", api.Configuration.URL, APIPath, APIResponses, env)
+	jsonMessage, err := json.Marshal(m)
+	if err != nil {
+		log.Printf("error marshaling data %s", err)
+	}
+	jsonParam := strings.NewReader(string(jsonMessage))
+	rawMute, err := api.PostGeneric(reqURL, jsonParam)
+	if err != nil {
+		return mute, fmt.Errorf("error api request - %v - %s", err, string(rawMute))
+	}
+	if err := json.Unmarshal(rawMute, &mute); err != nil {
+		return mute, fmt.Errorf("can not parse body - %v", err)
+	}
+	return mute, nil
+}
+
+// DeleteMute to lift a mute of an environment
+func (api *OsctrlAPI) DeleteMute(env string, id uint) (types.ApiGenericResponse, error) {
+	var r types.ApiGenericResponse
+	reqURL := fmt.Sprintf("%s%s%s/%s/mutes/delete", api.Configuration.URL, APIPath, APIResponses, env)
+	jsonMessage, err := json.Marshal(types.ApiMuteRequest{ID: id})
+	if err != nil {
+		log.Printf("error marshaling data %s", err)
+	}
+	jsonParam := strings.NewReader(string(jsonMessage))
+	rawR, err := api.PostGeneric(reqURL, jsonParam)
+	if err != nil {
+		return r, fmt.Errorf("error api request - %v - %s", err, string(rawR))
+	}
+	if err := json.Unmarshal(rawR, &r); err != nil {
+		return r, fmt.Errorf("can not parse body - %v", err)
+	}
+	return r, nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/jmpsec/osctrl/queries"
+	"github.com/jmpsec/osctrl/types"
+)
+
+// GetTemplates to retrieve all query templates from osctrl
+func (api *OsctrlAPI) GetTemplates() ([]queries.QueryTemplate, error) {
+	var ts []queries.QueryTemplate
+	reqURL := fmt.Sprintf("%s%s%s", api.Configuration.URL, APIPath, APITemplates)
+	rawTs, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return ts, fmt.Errorf("error api request - %v - %s", err, string(rawTs))
+	}
+	if err := json.Unmarshal(rawTs, &ts); err != nil {
+		return ts, fmt.Errorf("can not parse body - %v", err)
+	}
+	return ts, nil
+}
+
+// GetTemplate to retrieve one query template from osctrl
+func (api *OsctrlAPI) GetTemplate(name string) (queries.QueryTemplate, error) {
+	var t queries.QueryTemplate
+	reqURL := fmt.Sprintf("%s%s%s/%s", api.Configuration.URL, APIPath, APITemplates, name)
+	rawT, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return t, fmt.Errorf("error api request - %v - %s", err, string(rawT))
+	}
+	if err := json.Unmarshal(rawT, &t); err != nil {
+		return t, fmt.Errorf("can not parse body - %v", err)
+	}
+	return t, nil
+}
+
+// GetTemplateVersions to retrieve all the versions of a query template from osctrl
+func (api *OsctrlAPI) GetTemplateVersions(name string) ([]queries.QueryTemplateVersion, error) {
+	var vs []queries.QueryTemplateVersion
+	reqURL := fmt.Sprintf("%s%s%s/%s/versions", api.Configuration.URL, APIPath, APITemplates, name)
+	rawVs, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return vs, fmt.Errorf("error api request - %v - %s", err, string(rawVs))
+	}
+	if err := json.Unmarshal(rawVs, &vs); err != nil {
+		return vs, fmt.Errorf("can not parse body - %v", err)
+	}
+	return vs, nil
+}
+
+// SaveTemplate to create a query template in osctrl, or to update it if update is true
+func (api *OsctrlAPI) SaveTemplate(tr types.ApiQueryTemplateRequest, update bool) (queries.QueryTemplate, error) {
+	var t queries.QueryTemplate
+	reqURL := fmt.Sprintf("%s%s%s", api.Configuration.URL, APIPath, APITemplates)
+	if update {
+		reqURL = fmt.Sprintf("%s/%s", reqURL, tr.Name)
+	}
+	jsonMessage, err := json.Marshal(tr)
+	if err != nil {
+		log.Printf("error marshaling data %s", err)
+	}
+	jsonParam := strings.NewReader(string(jsonMessage))
+	rawT, err := api.PostGeneric(reqURL, jsonParam)
+	if err != nil {
+		return t, fmt.Errorf("error api request - %v - %s", err, string(rawT))
+	}
+	if err := json.Unmarshal(rawT, &t); err != nil {
+		return t, fmt.Errorf("can not parse body - %v", err)
+	}
+	return t, nil
+}
+
+// DeleteTemplate to delete a query template from osctrl
+func (api *OsctrlAPI) DeleteTemplate(name string, hard bool) error {
+	var r types.ApiGenericResponse
+	reqURL := fmt.Sprintf("%s%s%s/%s/delete", api.Configuration.URL, APIPath, APITemplates, name)
+	if hard {
+		reqURL += "?hard=true"
+	}
+	rawR, err := api.PostGeneric(reqURL, nil)
+	if err != nil {
+		return fmt.Errorf("error api request - %v - %s", err, string(rawR))
+	}
+	if err := json.Unmarshal(rawR, &r); err != nil {
+		return fmt.Errorf("can not parse body - %v", err)
+	}
+	return nil
+}
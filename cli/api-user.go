@@ -3,7 +3,9 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 
+	"github.com/jmpsec/osctrl/types"
 	"github.com/jmpsec/osctrl/users"
 )
 
@@ -39,3 +41,53 @@ func (api *OsctrlAPI) GetUser(username string) (users.AdminUser, error) {
 func (api *OsctrlAPI) DeleteUser(username string) error {
 	return nil
 }
+
+// GetUserPermissions to retrieve the permissions of one user from osctrl
+func (api *OsctrlAPI) GetUserPermissions(username string) (users.UserAccess, error) {
+	var a users.UserAccess
+	reqURL := fmt.Sprintf("%s%s%s/%s/permissions", api.Configuration.URL, APIPath, APIUSers, username)
+	rawA, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return a, fmt.Errorf("error api request - %v - %s", err, string(rawA))
+	}
+	if err := json.Unmarshal(rawA, &a); err != nil {
+		return a, fmt.Errorf("can not parse body - %v", err)
+	}
+	return a, nil
+}
+
+// SetUserPermissions to replace all the permissions of one user in osctrl
+func (api *OsctrlAPI) SetUserPermissions(username string, access users.UserAccess) (users.AccessChange, error) {
+	var c users.AccessChange
+	reqURL := fmt.Sprintf("%s%s%s/%s/permissions", api.Configuration.URL, APIPath, APIUSers, username)
+	jsonMessage, err := json.Marshal(access)
+	if err != nil {
+		return c, fmt.Errorf("error marshaling data %v", err)
+	}
+	rawC, err := api.PutGeneric(reqURL, strings.NewReader(string(jsonMessage)))
+	if err != nil {
+		return c, fmt.Errorf("error api request - %v - %s", err, string(rawC))
+	}
+	if err := json.Unmarshal(rawC, &c); err != nil {
+		return c, fmt.Errorf("can not parse body - %v", err)
+	}
+	return c, nil
+}
+
+// UnlockUser to unlock a username or an IP address locked out after failed logins
+func (api *OsctrlAPI) UnlockUser(username, ipaddress string) (types.ApiGenericResponse, error) {
+	var r types.ApiGenericResponse
+	reqURL := fmt.Sprintf("%s%s%s/unlock", api.Configuration.URL, APIPath, APIUSers)
+	jsonMessage, err := json.Marshal(types.ApiUnlockRequest{Username: username, IPAddress: ipaddress})
+	if err != nil {
+		return r, fmt.Errorf("error marshaling data %v", err)
+	}
+	rawR, err := api.PostGeneric(reqURL, strings.NewReader(string(jsonMessage)))
+	if err != nil {
+		return r, fmt.Errorf("error api request - %v - %s", err, string(rawR))
+	}
+	if err := json.Unmarshal(rawR, &r); err != nil {
+		return r, fmt.Errorf("can not parse body - %v", err)
+	}
+	return r, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/jmpsec/osctrl/version"
+)
+
+// APIVersion for the version path of all the services, outside of the API path
+const APIVersion = "/version"
+
+// GetVersion to retrieve the build of an osctrl service by its URL. The request does not need
+// authentication, so the token of the API is not sent to other services.
+func (api *OsctrlAPI) GetVersion(serviceURL string) (version.BuildInfo, error) {
+	var info version.BuildInfo
+	reqURL := strings.TrimSuffix(serviceURL, "/") + APIVersion
+	req, err := http.NewRequest(http.MethodGet, reqURL, nil)
+	if err != nil {
+		return info, fmt.Errorf("NewRequest - %v", err)
+	}
+	req.Header.Set(UserAgent, osctrlUserAgent)
+	resp, err := api.Client.Do(req)
+	if err != nil {
+		return info, fmt.Errorf("Client.Do - %v", err)
+	}
+	defer resp.Body.Close()
+	rawInfo, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return info, fmt.Errorf("can not read response - %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return info, fmt.Errorf("HTTP Code %d", resp.StatusCode)
+	}
+	if err := json.Unmarshal(rawInfo, &info); err != nil {
+		return info, fmt.Errorf("can not parse body - %v", err)
+	}
+	return info, nil
+}
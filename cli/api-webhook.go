@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/webhooks"
+)
+
+// GetWebhooks to retrieve the webhook endpoints of an environment
+func (api *OsctrlAPI) GetWebhooks(env string) ([]webhooks.WebhookEndpoint, error) {
+	var endpoints []webhooks.WebhookEndpoint
+	reqURL := fmt.Sprintf("%s%s%s/%s", api.Configuration.URL, APIPath, APIWebhooks, env)
+	rawEndpoints, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return endpoints, fmt.Errorf("error api request - %v - %s", err, string(rawEndpoints))
+	}
+	if err := json.Unmarshal(rawEndpoints, &endpoints); err != nil {
+		return endpoints, fmt.Errorf("can not parse body - %v", err)
+	}
+	return endpoints, nil
+}
+
+// CreateWebhook to create a webhook endpoint in an environment, returning its secret
+func (api *OsctrlAPI) CreateWebhook(env string, w types.ApiWebhookRequest) (types.ApiWebhookSecretResponse, error) {
+	var secret types.ApiWebhookSecretResponse
+	reqURL := fmt.Sprintf("%s%s%s/%s", api.Configuration.URL, APIPath, APIWebhooks, env)
+	jsonMessage, err := json.Marshal(w)
+	if err != nil {
+		log.Printf("error marshaling data %s", err)
+	}
+	jsonParam := strings.NewReader(string(jsonMessage))
+	rawSecret, err := api.PostGeneric(reqURL, jsonParam)
+	if err != nil {
+		return secret, fmt.Errorf("error api request - %v - %s", err, string(rawSecret))
+	}
+	if err := json.Unmarshal(rawSecret, &secret); err != nil {
+		return secret, fmt.Errorf("can not parse body - %v", err)
+	}
+	return secret, nil
+}
+
+// RotateWebhook to rotate the secret of a webhook endpoint, returning the new secret
+func (api *OsctrlAPI) RotateWebhook(env, name string) (types.ApiWebhookSecretResponse, error) {
+	var secret types.ApiWebhookSecretResponse
+	reqURL := fmt.Sprintf("%s%s%s/%s/%s/rotate", api.Configuration.URL, APIPath, APIWebhooks, env, url.PathEscape(name))
+	rawSecret, err := api.PostGeneric(reqURL, nil)
+	if err != nil {
+		return secret, fmt.Errorf("error api request - %v - %s", err, string(rawSecret))
+	}
+	if err := json.Unmarshal(rawSecret, &secret); err != nil {
+		return secret, fmt.Errorf("can not parse body - %v", err)
+	}
+	return secret, nil
+}
+
+// WebhookAction to enable, disable or delete a webhook endpoint
+func (api *OsctrlAPI) WebhookAction(env, name, action string) (types.ApiGenericResponse, error) {
+	var r types.ApiGenericResponse
+	reqURL := fmt.Sprintf("%s%s%s/%s/%s/%s", api.Configuration.URL, APIPath, APIWebhooks, env, url.PathEscape(name), action)
+	rawR, err := api.PostGeneric(reqURL, nil)
+	if err != nil {
+		return r, fmt.Errorf("error api request - %v - %s", err, string(rawR))
+	}
+	if err := json.Unmarshal(rawR, &r); err != nil {
+		return r, fmt.Errorf("can not parse body - %v", err)
+	}
+	return r, nil
+}
+
+// TestWebhook to send a test event to a webhook endpoint, returning the delivery
+func (api *OsctrlAPI) TestWebhook(env, name string) (webhooks.WebhookDelivery, error) {
+	var d webhooks.WebhookDelivery
+	reqURL := fmt.Sprintf("%s%s%s/%s/%s/test", api.Configuration.URL, APIPath, APIWebhooks, env, url.PathEscape(name))
+	rawD, err := api.PostGeneric(reqURL, nil)
+	if err != nil {
+		return d, fmt.Errorf("error api request - %v - %s", err, string(rawD))
+	}
+	if err := json.Unmarshal(rawD, &d); err != nil {
+		return d, fmt.Errorf("can not parse body - %v", err)
+	}
+	return d, nil
+}
+
+// GetWebhookDeliveries to retrieve the latest deliveries of a webhook endpoint, with one state or all if empty
+func (api *OsctrlAPI) GetWebhookDeliveries(env, name, state string, limit int) ([]webhooks.WebhookDelivery, error) {
+	var deliveries []webhooks.WebhookDelivery
+	reqURL := fmt.Sprintf("%s%s%s/%s/%s/deliveries?state=%s&limit=%d", api.Configuration.URL, APIPath, APIWebhooks, env, url.PathEscape(name), url.QueryEscape(state), limit)
+	rawDeliveries, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return deliveries, fmt.Errorf("error api request - %v - %s", err, string(rawDeliveries))
+	}
+	if err := json.Unmarshal(rawDeliveries, &deliveries); err != nil {
+		return deliveries, fmt.Errorf("can not parse body - %v", err)
+	}
+	return deliveries, nil
+}
+
+// RedeliverWebhook to queue again a delivery of a webhook endpoint
+func (api *OsctrlAPI) RedeliverWebhook(env, name string, id uint) (webhooks.WebhookDelivery, error) {
+	var d webhooks.WebhookDelivery
+	reqURL := fmt.Sprintf("%s%s%s/%s/%s/deliveries/%d/redeliver", api.Configuration.URL, APIPath, APIWebhooks, env, url.PathEscape(name), id)
+	rawD, err := api.PostGeneric(reqURL, nil)
+	if err != nil {
+		return d, fmt.Errorf("error api request - %v - %s", err, string(rawD))
+	}
+	if err := json.Unmarshal(rawD, &d); err != nil {
+		return d, fmt.Errorf("can not parse body - %v", err)
+	}
+	return d, nil
+}
+
+// GetWebhookHistory to retrieve all the webhook attempts for an entity of an environment
+func (api *OsctrlAPI) GetWebhookHistory(env, entity, id string) ([]webhooks.WebhookAttempt, error) {
+	var attempts []webhooks.WebhookAttempt
+	reqURL := fmt.Sprintf("%s%s%s/%s/history/%s/%s", api.Configuration.URL, APIPath, APIWebhooks, env, entity, url.PathEscape(id))
+	rawAttempts, err := api.GetGeneric(reqURL, nil)
+	if err != nil {
+		return attempts, fmt.Errorf("error api request - %v - %s", err, string(rawAttempts))
+	}
+	if err := json.Unmarshal(rawAttempts, &attempts); err != nil {
+		return attempts, fmt.Errorf("can not parse body - %v", err)
+	}
+	return attempts, nil
+}
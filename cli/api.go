@@ -28,6 +28,24 @@ const (
 	APIUSers = "/users"
 	// APILogin
 	APILogin = "/login"
+	// APIEnvironments for the environments path
+	APIEnvironments = "/environments"
+	// APIReports for the reports path
+	APIReports = "/reports"
+	// APITemplates for the query templates path
+	APITemplates = "/templates"
+	// APILogs for the logs path
+	APILogs = "/logs"
+	// APIResponses for the response actions path
+	APIResponses = "/responses"
+	// APIShares for the sharing exports path
+	APIShares = "/shares"
+	// APINotices for the notices path
+	APINotices = "/notices"
+	// APIExports for the export jobs path
+	APIExports = "/exports"
+	// APIWebhooks for the webhooks path
+	APIWebhooks = "/webhooks"
 	// JSONApplication for Content-Type headers
 	JSONApplication = "application/json"
 	// JSONApplicationUTF8 for Content-Type headers, UTF charset
@@ -135,6 +153,34 @@ func (api *OsctrlAPI) PostGeneric(url string, body io.Reader) ([]byte, error) {
 	return api.ReqGeneric(http.MethodPost, url, body)
 }
 
+// PutGeneric - Helper function to implement generic retrieval from API with a PUT request
+func (api *OsctrlAPI) PutGeneric(url string, body io.Reader) ([]byte, error) {
+	return api.ReqGeneric(http.MethodPut, url, body)
+}
+
+// StreamGeneric - Helper function to send a GET request to the API with extra headers, returning the response to read
+// the body as a stream. The caller closes the body.
+func (api *OsctrlAPI) StreamGeneric(url string, extra map[string]string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("NewRequest - %v", err)
+	}
+	// Set custom User-Agent
+	req.Header.Set(UserAgent, osctrlUserAgent)
+	// Prepare headers
+	for key, value := range api.Headers {
+		req.Header.Add(key, value)
+	}
+	for key, value := range extra {
+		req.Header.Set(key, value)
+	}
+	resp, err := api.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Client.Do - %v", err)
+	}
+	return resp, nil
+}
+
 // ReqGeneric - Helper function to implement generic retrieval from API with a POST request
 func (api *OsctrlAPI) ReqGeneric(reqType string, url string, body io.Reader) ([]byte, error) {
 	req, err := http.NewRequest(reqType, url, body)
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+	"github.com/olekukonko/tablewriter"
+	"github.com/urfave/cli/v2"
+)
+
+// Helper to show the state of a service key
+func apiKeyState(k users.APIKey, now time.Time) string {
+	if k.Revoked {
+		return "revoked"
+	}
+	if k.Expired(now) {
+		return "expired"
+	}
+	return "active"
+}
+
+// Helper to show times of service keys that may not be set
+func apiKeyTime(t time.Time, empty string) string {
+	if t.IsZero() {
+		return empty
+	}
+	return utils.PastFutureTimes(t)
+}
+
+func listAPIKeys(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ Environment name is required")
+		os.Exit(1)
+	}
+	var keys []users.APIKey
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		keys, err = adminUsers.APIKeys(env.UUID)
+		if err != nil {
+			return fmt.Errorf("error getting keys - %s", err)
+		}
+	} else if apiFlag {
+		keys, err = osctrlAPI.GetAPIKeys(envName)
+		if err != nil {
+			return fmt.Errorf("error getting keys - %s", err)
+		}
+	}
+	header := []string{
+		"Name",
+		"Key ID",
+		"Scopes",
+		"State",
+		"Expires",
+		"Last Used",
+		"Requests",
+		"Creator",
+	}
+	now := time.Now()
+	data := [][]string{}
+	for _, k := range keys {
+		data = append(data, []string{
+			k.Name,
+			k.KeyID,
+			k.Scopes,
+			apiKeyState(k, now),
+			apiKeyTime(k.ExpiresAt, "never"),
+			apiKeyTime(k.LastUsedAt, "never"),
+			strconv.FormatInt(k.Requests, 10),
+			k.Creator,
+		})
+	}
+	// Prepare output
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(keys)
+		if err != nil {
+			return fmt.Errorf("error marshaling - %s", err)
+		}
+		fmt.Println(string(jsonRaw))
+	} else if formatFlag == csvFormat {
+		w := csv.NewWriter(os.Stdout)
+		if err := w.WriteAll(append([][]string{header}, data...)); err != nil {
+			return fmt.Errorf("error writting csv - %s", err)
+		}
+	} else if formatFlag == prettyFormat {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader(header)
+		if len(keys) > 0 {
+			fmt.Printf("Existing service keys in %s (%d):\n", envName, len(keys))
+			table.AppendBulk(data)
+		} else {
+			fmt.Printf("No service keys in %s\n", envName)
+		}
+		table.Render()
+	}
+	return nil
+}
+
+// Helper to create or rotate a service key with the CLI flags
+func newAPIKey(c *cli.Context, rotate bool) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ Environment name is required")
+		os.Exit(1)
+	}
+	keyName := c.String("key")
+	if keyName == "" {
+		fmt.Println("❌ Key name is required")
+		os.Exit(1)
+	}
+	k := types.ApiKeyRequest{
+		Name:   keyName,
+		Days:   c.Int("days"),
+		Rotate: rotate,
+	}
+	if rotate {
+		k.Overlap = c.Int("overlap")
+	} else {
+		k.Scopes = strings.Join(c.StringSlice("scopes"), ",")
+	}
+	var key types.ApiKeyResponse
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		expires := users.APIKeyExpiration(k.Days, time.Now())
+		var created users.APIKey
+		var msg string
+		if rotate {
+			overlap := users.DefaultAPIKeyOverlap
+			if k.Overlap > 0 {
+				overlap = time.Duration(k.Overlap) * time.Hour
+			}
+			created, key.Key, err = adminUsers.RotateAPIKey(keyName, env.UUID, expires, overlap, appName)
+			msg = fmt.Sprintf("service key %s rotated, previous keys expire in %s", keyName, overlap)
+		} else {
+			scopes, serr := users.ParseScopes(k.Scopes)
+			if serr != nil {
+				return fmt.Errorf("invalid scopes - %s", serr)
+			}
+			created, key.Key, err = adminUsers.NewAPIKey(keyName, env.UUID, scopes, expires, appName)
+			msg = fmt.Sprintf("service key %s created with scopes %s", keyName, created.Scopes)
+		}
+		if err != nil {
+			return fmt.Errorf("error saving key - %s", err)
+		}
+		if err := envs.RecordActivity(env.ID, environments.ActivityAPIKeys, appName, msg, "", ""); err != nil {
+			log.Printf("error recording activity - %s", err)
+		}
+		key.Name = created.Name
+		key.KeyID = created.KeyID
+		key.Scopes = created.Scopes
+		key.ExpiresAt = created.ExpiresAt
+	} else if apiFlag {
+		key, err = osctrlAPI.NewAPIKey(envName, k)
+		if err != nil {
+			return fmt.Errorf("error saving key - %s", err)
+		}
+	}
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(key)
+		if err != nil {
+			return fmt.Errorf("error marshaling - %s", err)
+		}
+		fmt.Println(string(jsonRaw))
+		return nil
+	}
+	fmt.Printf("✅ Service key %s (%s) for %s with scopes %s, expires %s\n", key.Name, key.KeyID, envName, key.Scopes, apiKeyTime(key.ExpiresAt, "never"))
+	fmt.Println("⚠️  The key is only shown once, store it now:")
+	fmt.Println(key.Key)
+	return nil
+}
+
+func addAPIKey(c *cli.Context) error {
+	return newAPIKey(c, false)
+}
+
+func rotateAPIKey(c *cli.Context) error {
+	return newAPIKey(c, true)
+}
+
+func revokeAPIKey(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ Environment name is required")
+		os.Exit(1)
+	}
+	keyID := c.String("id")
+	if keyID == "" {
+		fmt.Println("❌ Key ID is required")
+		os.Exit(1)
+	}
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		if err := adminUsers.RevokeAPIKey(keyID, env.UUID); err != nil {
+			return fmt.Errorf("error revoking key - %s", err)
+		}
+		if err := envs.RecordActivity(env.ID, environments.ActivityAPIKeys, appName, "service key "+keyID+" revoked", "", ""); err != nil {
+			log.Printf("error recording activity - %s", err)
+		}
+	} else if apiFlag {
+		if _, err := osctrlAPI.RevokeAPIKey(envName, keyID); err != nil {
+			return fmt.Errorf("error revoking key - %s", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ Service key %s revoked in %s\n", keyID, envName)
+	}
+	return nil
+}
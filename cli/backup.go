@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/jmpsec/osctrl/backend"
+	"github.com/jmpsec/osctrl/backup"
+	"github.com/jmpsec/osctrl/carves"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/logging"
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/jmpsec/osctrl/queries"
+	"github.com/jmpsec/osctrl/reports"
+	"github.com/jmpsec/osctrl/responses"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/tags"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/version"
+	"github.com/jmpsec/osctrl/webhooks"
+	"github.com/olekukonko/tablewriter"
+	"github.com/urfave/cli/v2"
+)
+
+// Tables of the state of osctrl, in the order they are restored. Secrets of environments and keys of nodes are
+// included, so enrolled nodes keep working with a restored database
+var backupTables = []backup.Table{
+	// Environments
+	{Model: environments.TLSEnvironment{}},
+	{Model: environments.EnvCertificate{}},
+	{Model: environments.NodeCertificate{}},
+	{Model: environments.ScheduleOverride{}},
+	{Model: environments.ScheduleDeny{}},
+	{Model: environments.EnrollField{}},
+	{Model: environments.PerformanceProfile{}},
+	{Model: environments.WindowsEventChannel{}},
+	{Model: environments.EnvActivity{}},
+	{Model: environments.StormState{}},
+	{Model: environments.StorageQuota{}},
+	{Model: environments.StorageSample{}},
+	{Model: environments.ConfigVersion{}},
+	{Model: environments.ConfigInput{}},
+	{Model: environments.NodeConfigLineage{}},
+	{Model: environments.PackSource{}},
+	{Model: environments.PackSyncPack{}},
+	{Model: environments.EnvTemplate{}},
+	{Model: environments.EnvTemplateVersion{}},
+	{Model: environments.PseudonymMapping{}},
+	{Model: environments.ScheduledChange{}},
+	{Model: environments.CategoryPolicy{}},
+	// Nodes
+	{Model: nodes.OsqueryNode{}},
+	{Model: nodes.ArchiveOsqueryNode{}},
+	{Model: nodes.EnvPlatform{}},
+	{Model: nodes.NodeDecommission{}},
+	{Model: nodes.NodePause{}},
+	{Model: nodes.EnrollRejection{}},
+	{Model: nodes.NodeHistoryIPAddress{}},
+	{Model: nodes.NodeHistoryHostname{}},
+	{Model: nodes.NodeHistoryLocalname{}},
+	{Model: nodes.NodeHistoryUsername{}},
+	{Model: nodes.NodeCountSnapshot{}},
+	{Model: nodes.NodeMembership{}},
+	{Model: nodes.NodeMerge{}},
+	{Model: nodes.NodeMergeRow{}},
+	{Model: nodes.NodeIdentity{}},
+	{Model: nodes.NodeAttribute{}},
+	{Model: nodes.EnrollLoop{}},
+	// Tags
+	{Model: tags.AdminTag{}},
+	{Model: tags.TaggedNode{}},
+	{Model: tags.TagJob{}},
+	{Model: tags.TagJobNode{}},
+	{Model: tags.TagJobConflict{}},
+	// Users
+	{Model: users.AdminUser{}},
+	{Model: users.UserPermission{}},
+	{Model: users.PermissionChange{}},
+	{Model: users.APIKey{}},
+	{Model: users.LoginLockout{}},
+	{Model: users.TeamMember{}},
+	{Model: users.Notice{}},
+	{Model: users.NoticeAck{}},
+	// Settings
+	{Model: settings.SettingValue{}},
+	// Queries
+	{Model: queries.DistributedQuery{}},
+	{Model: queries.DistributedQueryExecution{}},
+	{Model: queries.DistributedQueryTarget{}},
+	{Model: queries.DistributedQueryLabel{}},
+	{Model: queries.NodeQuery{}},
+	{Model: queries.SavedQuery{}},
+	{Model: queries.QueryTemplate{}},
+	{Model: queries.QueryTemplateVersion{}},
+	{Model: queries.QueryProfileSample{}},
+	{Model: queries.QueryProfileResult{}},
+	{Model: queries.QueryGroup{}},
+	{Model: queries.QueryPolicy{}},
+	{Model: queries.QueryAccessGrant{}},
+	{Model: queries.QueryArchive{}},
+	{Model: queries.QuickAction{}},
+	{Model: queries.QuickActionVersion{}},
+	// Carves
+	{Model: carves.CarvedFile{}},
+	{Model: carves.CarveLimits{}},
+	{Model: carves.CarveRejection{}},
+	{Model: carves.CarvePathOverride{}},
+	{Model: queries.RecurringCarve{}},
+	{Model: queries.RecurringCarveRun{}},
+	// Response actions
+	{Model: responses.ResponseConfig{}},
+	{Model: responses.ResponseTrigger{}},
+	{Model: responses.ResponseAction{}},
+	{Model: responses.Mute{}},
+	// Webhooks
+	{Model: webhooks.WebhookEndpoint{}},
+	{Model: webhooks.WebhookDelivery{}},
+	{Model: webhooks.WebhookAttempt{}},
+	// Reports
+	{Model: reports.Report{}},
+	{Model: reports.ReportSchedule{}},
+	// Logs and content of carves, only with --results
+	{Model: carves.CarvedBlock{}, Optional: true},
+	{Model: logging.OsqueryStatusData{}, Optional: true},
+	{Model: logging.OsqueryResultData{}, Optional: true},
+	{Model: logging.OsqueryQueryData{}, Optional: true},
+	{Model: logging.AlwaysLogTraffic{}, Optional: true},
+	{Model: environments.StorageCounter{}, Optional: true},
+	{Model: logging.DeadLetter{}, Optional: true},
+}
+
+// Helper to get the passphrase of archives from the flags
+func backupPassphrase(c *cli.Context) (string, error) {
+	pass := c.String("passphrase")
+	if file := c.String("passphrase-file"); file != "" {
+		raw, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("error reading passphrase file - %s", err)
+		}
+		pass = strings.TrimRight(string(raw), "\r\n")
+	}
+	if pass == "" {
+		return "", fmt.Errorf("passphrase is required, the archive contains secrets")
+	}
+	return pass, nil
+}
+
+// Helper to connect to the DB directly, without initializing the managers that could insert rows
+func backupDB() (*backend.DBManager, error) {
+	if !dbFlag {
+		return nil, fmt.Errorf("backups require a DB connection (--db)")
+	}
+	if dbConfigFile != "" {
+		return backend.CreateDBManagerFile(dbConfigFile)
+	}
+	return backend.CreateDBManager(dbConfig)
+}
+
+// Helper to show the tables of a manifest
+func backupReport(manifest backup.Manifest, action string) error {
+	header := []string{
+		"Table",
+		"Rows",
+		"SHA256",
+	}
+	data := [][]string{}
+	var total int64
+	for _, t := range manifest.Tables {
+		data = append(data, []string{
+			t.Name,
+			strconv.FormatInt(t.Rows, 10),
+			t.SHA256,
+		})
+		total += t.Rows
+	}
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(manifest)
+		if err != nil {
+			return fmt.Errorf("error marshaling - %s", err)
+		}
+		fmt.Println(string(jsonRaw))
+	} else if formatFlag == csvFormat {
+		w := csv.NewWriter(os.Stdout)
+		if err := w.WriteAll(append([][]string{header}, data...)); err != nil {
+			return fmt.Errorf("error writting csv - %s", err)
+		}
+	} else if formatFlag == prettyFormat {
+		fmt.Printf("✅ %s %d tables and %d rows (osctrl %s, %s)\n", action, len(manifest.Tables), total, manifest.Service, manifest.CreatedAt.Format("2006-01-02 15:04:05 MST"))
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader(header)
+		table.AppendBulk(data)
+		table.Render()
+	}
+	return nil
+}
+
+func createBackup(c *cli.Context) error {
+	output := c.String("output")
+	if output == "" {
+		fmt.Println("❌ Output file is required")
+		os.Exit(1)
+	}
+	pass, err := backupPassphrase(c)
+	if err != nil {
+		return err
+	}
+	db, err = backupDB()
+	if err != nil {
+		return fmt.Errorf("error connecting to DB - %s", err)
+	}
+	f, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("error creating file - %s", err)
+	}
+	manifest, err := backup.Create(db.Conn, backupTables, f, pass, backup.Options{
+		Service:   version.OsctrlVersion,
+		Optional:  c.Bool("results"),
+		BatchSize: c.Int("batch"),
+	})
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(output)
+		return fmt.Errorf("error creating backup - %s", err)
+	}
+	if silentFlag {
+		return nil
+	}
+	return backupReport(manifest, "Backup "+output+" created with")
+}
+
+func restoreBackup(c *cli.Context) error {
+	input := c.String("input")
+	if input == "" {
+		fmt.Println("❌ Input file is required")
+		os.Exit(1)
+	}
+	pass, err := backupPassphrase(c)
+	if err != nil {
+		return err
+	}
+	db, err = backupDB()
+	if err != nil {
+		return fmt.Errorf("error connecting to DB - %s", err)
+	}
+	f, err := os.Open(input)
+	if err != nil {
+		return fmt.Errorf("error opening file - %s", err)
+	}
+	defer f.Close()
+	manifest, err := backup.Restore(db.Conn, backupTables, f, pass, c.Int("batch"))
+	if err != nil {
+		return fmt.Errorf("error restoring backup - %s", err)
+	}
+	if silentFlag {
+		return nil
+	}
+	return backupReport(manifest, "Backup "+input+" restored with")
+}
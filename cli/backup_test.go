@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/backup"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/metrics"
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/jmpsec/osctrl/tls/handlers"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	restoreNodeKey   = "2b9bd1c5-4f0e-4d7c-a2d3-9c8d1e5f7a60"
+	restoreNodeUUID  = "3C1F4A2E-8B5D-4E6F-9A0B-1C2D3E4F5A6B"
+	restoreEnvSecret = "enroll-secret-of-dev"
+	restoreEnvConf   = `{"options":{"host_identifier":"uuid"}}`
+)
+
+// Values written to a mocked database, to check what a restore inserted
+type valueRecorder struct {
+	mux    sync.Mutex
+	values []driver.Value
+}
+
+func (r *valueRecorder) ConvertValue(v interface{}) (driver.Value, error) {
+	value, err := driver.DefaultParameterConverter.ConvertValue(v)
+	if err == nil {
+		r.mux.Lock()
+		r.values = append(r.values, value)
+		r.mux.Unlock()
+	}
+	return value, err
+}
+
+func (r *valueRecorder) has(v driver.Value) bool {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	for _, value := range r.values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Dialector without migrations, tables of a mocked database already exist
+type restoreDialector struct {
+	postgres.Dialector
+}
+
+func (d restoreDialector) Migrator(db *gorm.DB) gorm.Migrator {
+	return restoreMigrator{d.Dialector.Migrator(db)}
+}
+
+type restoreMigrator struct {
+	gorm.Migrator
+}
+
+func (restoreMigrator) AutoMigrate(dst ...interface{}) error {
+	return nil
+}
+
+// Helper to open a mocked postgres database
+func openMock(t *testing.T) (*gorm.DB, sqlmock.Sqlmock) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db, got error: %v", err)
+	}
+	t.Cleanup(func() { mockDB.Close() })
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: mockDB}), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("unable to create new postgres database: %v", err)
+	}
+	return db, mock
+}
+
+// Helper to get the table names of the backup in the order they are backed up, optional tables only if requested
+func backupTableNames(t *testing.T, db *gorm.DB, optional bool) []string {
+	var names []string
+	for _, table := range backupTables {
+		if table.Optional && !optional {
+			continue
+		}
+		stmt := &gorm.Statement{DB: db}
+		assert.NoError(t, stmt.Parse(table.Model))
+		names = append(names, stmt.Schema.Table)
+	}
+	return names
+}
+
+// Helper to get the tables of the backup with a sequence of primary keys, moved after the restored rows
+func sequenceTableNames(t *testing.T, db *gorm.DB) []string {
+	var names []string
+	for _, table := range backupTables {
+		if table.Optional {
+			continue
+		}
+		stmt := &gorm.Statement{DB: db}
+		assert.NoError(t, stmt.Parse(table.Model))
+		pk := stmt.Schema.PrioritizedPrimaryField
+		if pk != nil && pk.AutoIncrement && (pk.DataType == schema.Uint || pk.DataType == schema.Int) {
+			names = append(names, stmt.Schema.Table)
+		}
+	}
+	return names
+}
+
+// Helper to create an archive of an instance with one environment and one enrolled node
+func backupInstance(t *testing.T, passphrase string) []byte {
+	db, mock := openMock(t)
+	rows := map[string]*sqlmock.Rows{
+		"tls_environments": sqlmock.NewRows([]string{"id", "uuid", "name", "secret", "configuration", "created_at"}).
+			AddRow(1, "ENV-UUID", "dev", restoreEnvSecret, restoreEnvConf, time.Now()),
+		"osquery_nodes": sqlmock.NewRows([]string{"id", "node_key", "uuid", "platform", "environment", "environment_id", "created_at"}).
+			AddRow(7, restoreNodeKey, restoreNodeUUID, "ubuntu", "dev", 1, time.Now()),
+	}
+	mock.ExpectBegin()
+	for _, name := range backupTableNames(t, db, false) {
+		r, ok := rows[name]
+		if !ok {
+			r = sqlmock.NewRows([]string{"id"})
+		}
+		mock.ExpectQuery(`SELECT \* FROM "` + name + `"`).WillReturnRows(r)
+	}
+	mock.ExpectRollback()
+	var archive bytes.Buffer
+	manifest, err := backup.Create(db, backupTables, &archive, passphrase, backup.Options{Service: "test"})
+	assert.NoError(t, err)
+	assert.Len(t, manifest.Tables, len(backupTableNames(t, db, false)))
+	assert.NoError(t, mock.ExpectationsWereMet())
+	return archive.Bytes()
+}
+
+// Helper to restore an archive into an empty mocked database, returns the values inserted
+func restoreInstance(t *testing.T, archive []byte, passphrase string) *valueRecorder {
+	recorder := &valueRecorder{}
+	mockDB, mock, err := sqlmock.New(sqlmock.ValueConverterOption(recorder))
+	if err != nil {
+		t.Fatalf("failed to open mock sql db, got error: %v", err)
+	}
+	defer mockDB.Close()
+	db, err := gorm.Open(restoreDialector{*postgres.New(postgres.Config{Conn: mockDB}).(*postgres.Dialector)}, &gorm.Config{})
+	if err != nil {
+		t.Fatalf("unable to create new postgres database: %v", err)
+	}
+	mock.MatchExpectationsInOrder(false)
+	// The restore checks that all the tables are empty, and restores the ones in the archive
+	for _, name := range backupTableNames(t, db, true) {
+		mock.ExpectQuery(`SELECT count\(\*\) FROM "` + name + `"`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	}
+	mock.ExpectBegin()
+	for _, name := range []string{"tls_environments", "osquery_nodes"} {
+		mock.ExpectExec(`SAVEPOINT`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`INSERT INTO "` + name + `"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	}
+	for _, name := range sequenceTableNames(t, db) {
+		mock.ExpectExec(`SELECT setval\(pg_get_serial_sequence\('` + name + `'`).WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	mock.ExpectCommit()
+	_, err = backup.Restore(db, backupTables, bytes.NewReader(archive), passphrase, 0)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	return recorder
+}
+
+// A restored instance accepts check-ins of the nodes enrolled before the backup, with their existing node keys
+func TestBackupRestoreCheckin(t *testing.T) {
+	archive := backupInstance(t, "correct horse")
+	restored := restoreInstance(t, archive, "correct horse")
+	for _, v := range []driver.Value{restoreNodeKey, restoreNodeUUID, restoreEnvSecret, restoreEnvConf} {
+		assert.True(t, restored.has(v), "%v was not restored", v)
+	}
+	// Check-in of the node against the restored rows
+	db, mock := openMock(t)
+	mock.MatchExpectationsInOrder(false)
+	mock.ExpectQuery(`SELECT \* FROM "tls_environments"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "secret", "configuration"}).AddRow(1, "dev", restoreEnvSecret, restoreEnvConf))
+	mock.ExpectQuery(`SELECT \* FROM "osquery_nodes" WHERE node_key = \$1`).
+		WithArgs(restoreNodeKey).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "node_key", "uuid", "platform", "environment_id"}).AddRow(7, restoreNodeKey, restoreNodeUUID, "ubuntu", 1))
+	envsmap := environments.MapEnvironments{"dev": environments.TLSEnvironment{Name: "dev"}}
+	h := handlers.CreateHandlersTLS(
+		handlers.WithEnvs(&environments.Environment{DB: db}),
+		handlers.WithNodes(&nodes.NodeManager{DB: db}),
+		handlers.WithEnvsMap(&envsmap),
+		handlers.WithIngested(&metrics.IngestedManager{DB: db}),
+	)
+	body := fmt.Sprintf(`{"node_key": %q}`, restoreNodeKey)
+	req, _ := http.NewRequest("POST", "/dev/config", strings.NewReader(body))
+	req = mux.SetURLVars(req, map[string]string{"environment": "dev"})
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(h.ConfigHandler).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var conf map[string]interface{}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &conf))
+	assert.NotContains(t, conf, "node_invalid")
+	assert.Contains(t, conf, "options")
+	// Node keys are only in the encrypted archive
+	assert.False(t, bytes.Contains(archive, []byte(restoreNodeKey)))
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/jmpsec/osctrl/cache"
+	"github.com/olekukonko/tablewriter"
+	"github.com/urfave/cli/v2"
+)
+
+// Flags to connect directly to redis, shared by the cache commands
+var redisFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:    "redis-file",
+		Usage:   "Load redis configuration from `FILE`",
+		EnvVars: []string{"REDIS_CONFIG_FILE"},
+	},
+	&cli.StringFlag{
+		Name:    "redis-connection-string",
+		Usage:   "Redis connection string, must include schema (<redis|rediss|unix>://<user>:<pass>@<host>:<port>/<db>?<options>",
+		EnvVars: []string{"REDIS_CONNECTION_STRING"},
+	},
+	&cli.StringFlag{
+		Name:    "redis-host",
+		Value:   "127.0.0.1",
+		Usage:   "Redis host to be connected to",
+		EnvVars: []string{"REDIS_HOST"},
+	},
+	&cli.StringFlag{
+		Name:    "redis-port",
+		Value:   "6379",
+		Usage:   "Redis port to be connected to",
+		EnvVars: []string{"REDIS_PORT"},
+	},
+	&cli.StringFlag{
+		Name:    "redis-pass",
+		Usage:   "Password to be used for redis",
+		EnvVars: []string{"REDIS_PASS"},
+	},
+	&cli.IntFlag{
+		Name:    "redis-db",
+		Value:   0,
+		Usage:   "Redis database to be selected after connecting",
+		EnvVars: []string{"REDIS_DB"},
+	},
+}
+
+// Helper to check if redis is used directly, instead of the API
+func redisDirect(c *cli.Context) bool {
+	return !apiFlag || c.IsSet("redis-file") || c.IsSet("redis-connection-string") || c.IsSet("redis-host")
+}
+
+// Helper to connect to redis with the flags of the cache commands
+func cacheRedis(c *cli.Context) (*cache.RedisManager, error) {
+	if c.String("redis-file") != "" {
+		return cache.CreateRedisManagerFile(c.String("redis-file"))
+	}
+	return cache.CreateRedisManager(cache.JSONConfigurationRedis{
+		ConnectionString: c.String("redis-connection-string"),
+		Host:             c.String("redis-host"),
+		Port:             c.String("redis-port"),
+		Password:         c.String("redis-pass"),
+		DB:               c.Int("redis-db"),
+	})
+}
+
+func cacheStats(c *cli.Context) error {
+	var stats cache.CacheStats
+	if redisDirect(c) {
+		rm, err := cacheRedis(c)
+		if err != nil {
+			return fmt.Errorf("error connecting to redis - %s", err)
+		}
+		if stats, err = rm.Stats(c.Int("sample")); err != nil {
+			return fmt.Errorf("error getting stats - %s", err)
+		}
+	} else {
+		if stats, err = osctrlAPI.GetCacheStats(c.Int("sample")); err != nil {
+			return fmt.Errorf("error getting stats - %s", err)
+		}
+	}
+	header := []string{
+		"Family",
+		"Prefix",
+		"Keys",
+		"Memory (estimated)",
+		"Sampled",
+		"Without TTL",
+	}
+	data := [][]string{}
+	for _, f := range stats.Families {
+		data = append(data, []string{
+			f.Family,
+			f.Prefix,
+			strconv.FormatInt(f.Keys, 10),
+			strconv.FormatInt(f.MemoryBytes, 10),
+			strconv.Itoa(f.Sampled),
+			strconv.Itoa(f.NoExpiration),
+		})
+	}
+	// Prepare output
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(stats)
+		if err != nil {
+			return fmt.Errorf("error marshaling - %s", err)
+		}
+		fmt.Println(string(jsonRaw))
+	} else if formatFlag == csvFormat {
+		w := csv.NewWriter(os.Stdout)
+		if err := w.WriteAll(append([][]string{header}, data...)); err != nil {
+			return fmt.Errorf("error writting csv - %s", err)
+		}
+	} else if formatFlag == prettyFormat {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader(header)
+		fmt.Printf("Keys in redis (%d):\n", stats.Keys)
+		table.AppendBulk(data)
+		table.Render()
+	}
+	return nil
+}
+
+func cachePurge(c *cli.Context) error {
+	prefix := c.String("prefix")
+	if prefix == "" {
+		fmt.Println("❌ Prefix is required")
+		os.Exit(1)
+	}
+	rm, err := cacheRedis(c)
+	if err != nil {
+		return fmt.Errorf("error connecting to redis - %s", err)
+	}
+	if !cache.ValidPurgeMatch(rm.Families(), prefix) {
+		var prefixes []string
+		for _, f := range rm.Families() {
+			prefixes = append(prefixes, f.Prefix+"*")
+		}
+		return fmt.Errorf("prefix %s is not a family of keys of osctrl, use one of %s", prefix, strings.Join(prefixes, ", "))
+	}
+	ctx := context.Background()
+	if !c.Bool("yes") {
+		count, err := rm.Count(ctx, prefix, c.Int("batch"), c.Duration("pause"))
+		if err != nil {
+			return fmt.Errorf("error counting keys - %s", err)
+		}
+		fmt.Printf("Remove %d keys matching %s? [y/N]: ", count, prefix)
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+			fmt.Println("Purge cancelled")
+			return nil
+		}
+	}
+	removed, err := rm.Purge(ctx, prefix, c.Int("batch"), c.Duration("pause"))
+	if err != nil {
+		return fmt.Errorf("error purging keys - %s", err)
+	}
+	if !silentFlag {
+		fmt.Printf("✅ %d keys matching %s were removed\n", removed, prefix)
+	}
+	return nil
+}
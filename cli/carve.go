@@ -6,9 +6,15 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/jmpsec/osctrl/backend"
 	"github.com/jmpsec/osctrl/carves"
+	"github.com/jmpsec/osctrl/environments"
 	"github.com/jmpsec/osctrl/queries"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/utils"
 	"github.com/olekukonko/tablewriter"
 	"github.com/urfave/cli/v2"
 )
@@ -40,6 +46,7 @@ func carveToData(c carves.CarvedFile, header []string) [][]string {
 		c.Carver,
 		stringifyBool(c.Archived),
 		c.ArchivePath,
+		c.ScanVerdict,
 	}
 	data = append(data, _c)
 	return data
@@ -92,6 +99,7 @@ func listCarves(c *cli.Context) error {
 		"Carver",
 		"Archived",
 		"ArchivePath",
+		"Scan",
 	}
 	// Prepare output
 	if formatFlag == jsonFormat {
@@ -162,9 +170,12 @@ func deleteCarve(c *cli.Context) error {
 		if err != nil {
 			return err
 		}
-		return queriesmgr.Delete(name, e.ID)
+		if c.Bool("hard") {
+			return queriesmgr.HardDelete(name, e.ID, backend.TrashCarves, appName)
+		}
+		return queriesmgr.Delete(name, e.ID, appName)
 	} else if apiFlag {
-		return osctrlAPI.DeleteQuery(env, name)
+		return osctrlAPI.DeleteQuery(env, name, c.Bool("hard"))
 	}
 	return nil
 }
@@ -181,9 +192,25 @@ func runCarve(c *cli.Context) error {
 		fmt.Println("❌ environment is required")
 		os.Exit(1)
 	}
+	window, err := windowFlags(c)
+	if err != nil {
+		fmt.Printf("❌ %s\n", err)
+		os.Exit(1)
+	}
+	attrFilters, err := queries.ParseAttributeTargets(c.StringSlice("attribute"))
+	if err != nil {
+		fmt.Printf("❌ %s\n", err)
+		os.Exit(1)
+	}
 	uuid := c.String("uuid")
-	if uuid == "" {
-		fmt.Println("❌ UUID is required")
+	if uuid == "" && window.Empty() && len(attrFilters) == 0 {
+		fmt.Println("❌ UUID, time window or attributes are required")
+		os.Exit(1)
+	}
+	visibility := c.String("visibility")
+	team := c.String("team")
+	if err := queries.ValidateVisibility(visibility, team); err != nil {
+		fmt.Printf("❌ %s\n", err)
 		os.Exit(1)
 	}
 	if dbFlag {
@@ -191,19 +218,34 @@ func runCarve(c *cli.Context) error {
 		if err != nil {
 			return err
 		}
+		if err := adminUsers.CheckConfirmation(e.UUID, c.Bool("confirm-notices")); err != nil {
+			return err
+		}
+		if err := checkLaunch(e, uuid, !window.Empty() || len(attrFilters) > 0, c.String("confirm-name")); err != nil {
+			return err
+		}
+		quota, err := envs.GetStorageQuota(e.ID)
+		if err != nil {
+			return err
+		}
+		if quota.Active(environments.QuotaBlockCarves) {
+			return fmt.Errorf("storage quota of %s exceeded, new carves are blocked", env)
+		}
 		carveName := carves.GenCarveName()
 		newQuery := queries.DistributedQuery{
-			Query:         carves.GenCarveQuery(path, false),
-			Name:          carveName,
-			Creator:       appName,
-			Expected:      0,
-			Executions:    0,
-			Active:        true,
-			Completed:     false,
-			Deleted:       false,
-			Type:          queries.CarveQueryType,
-			Path:          path,
-			EnvironmentID: e.ID,
+			Query:          carves.GenCarveQuery(path, false),
+			Name:           carveName,
+			Creator:        appName,
+			Expected:       0,
+			Executions:     0,
+			Active:         true,
+			Completed:      false,
+			Deleted:        false,
+			Type:           queries.CarveQueryType,
+			Path:           path,
+			EnvironmentID:  e.ID,
+			Visibility:     visibility,
+			VisibilityTeam: team,
 		}
 		if err := queriesmgr.Create(newQuery); err != nil {
 			return err
@@ -213,12 +255,39 @@ func runCarve(c *cli.Context) error {
 				return err
 			}
 		}
-		if err := queriesmgr.SetExpected(carveName, 1, e.ID); err != nil {
+		attrTargets, err := queriesmgr.CreateAttributeTargets(carveName, attrFilters)
+		if err != nil {
+			return err
+		}
+		if err := queriesmgr.CreateWindowTargets(carveName, window); err != nil {
+			return err
+		}
+		targeted, err := queriesmgr.Materialize(carveName, e.ID)
+		if err != nil {
+			return err
+		}
+		expected := 1
+		if !window.Empty() || attrTargets > 0 {
+			expected = targeted
+		}
+		if err := queriesmgr.SetExpected(carveName, expected, e.ID); err != nil {
 			return err
 		}
 		return nil
 	} else if apiFlag {
-		c, err := osctrlAPI.RunCarve(env, uuid, path)
+		c, err := osctrlAPI.RunCarve(env, types.ApiDistributedCarveRequest{
+			UUID:           uuid,
+			Path:           path,
+			EnrolledAfter:  c.String("enrolled-after"),
+			EnrolledBefore: c.String("enrolled-before"),
+			SeenAfter:      c.String("seen-after"),
+			SeenBefore:     c.String("seen-before"),
+			Attributes:     c.StringSlice("attribute"),
+			ConfirmNotices: c.Bool("confirm-notices"),
+			ConfirmName:    c.String("confirm-name"),
+			Visibility:     visibility,
+			VisibilityTeam: team,
+		})
 		if err != nil {
 			return err
 		}
@@ -228,3 +297,234 @@ func runCarve(c *cli.Context) error {
 	}
 	return nil
 }
+
+func scanCarve(c *cli.Context) error {
+	// Get values from flags
+	session := c.String("session")
+	if session == "" {
+		fmt.Println("❌ carve session is required")
+		os.Exit(1)
+	}
+	env := c.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	if dbFlag {
+		scanner := carves.ScannerFromSettings(settingsmgr)
+		if scanner == nil {
+			return fmt.Errorf("no carve scanner configured")
+		}
+		// Scan synchronously, the process ends after the command
+		if err := filecarves.SetVerdict(session, carves.ScanPending, ""); err != nil {
+			return err
+		}
+		res, err := filecarves.Scan(scanner, session)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("✅ carve %s is %s %s\n", session, res.Verdict, res.Detail)
+	} else if apiFlag {
+		if err := osctrlAPI.ScanCarve(env, session); err != nil {
+			return err
+		}
+		fmt.Printf("✅ scan of carve %s started\n", session)
+	}
+	return nil
+}
+
+func createRecurringCarve(c *cli.Context) error {
+	// Get values from flags
+	env := c.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	req := types.ApiRecurringCarveRequest{
+		CaseID:     c.String("case"),
+		Paths:      c.StringSlice("path"),
+		UUIDs:      c.StringSlice("uuid"),
+		Recurrence: c.String("recurrence"),
+		EndAt:      c.String("end"),
+	}
+	var name string
+	if dbFlag {
+		e, err := envs.Get(env)
+		if err != nil {
+			return err
+		}
+		var endAt time.Time
+		if req.EndAt != "" {
+			if endAt, err = time.Parse(time.RFC3339, req.EndAt); err != nil {
+				return fmt.Errorf("invalid end date %s", err)
+			}
+		}
+		rc, err := queriesmgr.NewRecurringCarve(e.ID, req.CaseID, req.Paths, req.UUIDs, req.Recurrence, endAt, appName)
+		if err != nil {
+			return fmt.Errorf("error creating recurring carve %s", err)
+		}
+		name = rc.Name
+	} else if apiFlag {
+		r, err := osctrlAPI.CreateRecurringCarve(env, req)
+		if err != nil {
+			return fmt.Errorf("error creating recurring carve %s", err)
+		}
+		name = r.Name
+	}
+	if !silentFlag {
+		fmt.Printf("✅ recurring carve %s created successfully for case %s\n", name, req.CaseID)
+	}
+	return nil
+}
+
+func listRecurringCarves(c *cli.Context) error {
+	// Get values from flags
+	env := c.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	var rs []queries.RecurringCarveDetail
+	if dbFlag {
+		e, err := envs.Get(env)
+		if err != nil {
+			return err
+		}
+		recurring, err := queriesmgr.GetRecurringCarves(e.ID)
+		if err != nil {
+			return err
+		}
+		for _, r := range recurring {
+			footprint := queries.CaseFootprint{CaseID: r.CaseID}
+			names, err := queriesmgr.CaseQueryNames(r.CaseID)
+			if err != nil {
+				return err
+			}
+			if footprint.Bytes, footprint.Carves, err = filecarves.Footprint(names); err != nil {
+				return err
+			}
+			rs = append(rs, queries.RecurringCarveDetail{Recurring: r, Footprint: footprint})
+		}
+	} else if apiFlag {
+		rs, err = osctrlAPI.GetRecurringCarves(env)
+		if err != nil {
+			return err
+		}
+	}
+	header := []string{
+		"Name",
+		"Case",
+		"Status",
+		"Recurrence",
+		"Next Run",
+		"End",
+		"Nodes",
+		"Paths",
+		"Carves",
+		"Storage",
+	}
+	// Prepare output
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(rs)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(jsonRaw))
+	} else if formatFlag == csvFormat {
+		data := recurringCarvesToData(rs, header)
+		w := csv.NewWriter(os.Stdout)
+		if err := w.WriteAll(data); err != nil {
+			return err
+		}
+	} else if formatFlag == prettyFormat {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader(header)
+		if len(rs) > 0 {
+			fmt.Printf("Existing recurring carves (%d):\n", len(rs))
+			table.AppendBulk(recurringCarvesToData(rs, nil))
+		} else {
+			fmt.Println("No recurring carves")
+		}
+		table.Render()
+	}
+	return nil
+}
+
+// Helper function to convert a slice of recurring carves into the data expected for output
+func recurringCarvesToData(rs []queries.RecurringCarveDetail, header []string) [][]string {
+	var data [][]string
+	if header != nil {
+		data = append(data, header)
+	}
+	for _, r := range rs {
+		end := "until stopped"
+		if !r.Recurring.EndAt.IsZero() {
+			end = r.Recurring.EndAt.Format(time.RFC3339)
+		}
+		data = append(data, []string{
+			r.Recurring.Name,
+			r.Recurring.CaseID,
+			r.Recurring.Status,
+			r.Recurring.Recurrence,
+			r.Recurring.NextRun.Format(time.RFC3339),
+			end,
+			strconv.Itoa(len(r.Recurring.UUIDList())),
+			strings.Join(r.Recurring.PathList(), ", "),
+			strconv.FormatInt(r.Footprint.Carves, 10),
+			utils.BytesReceivedConversion(int(r.Footprint.Bytes)),
+		})
+	}
+	return data
+}
+
+// Helper to pause, resume or stop a recurring carve
+func actionRecurringCarve(c *cli.Context, action string) error {
+	// Get values from flags
+	name := c.String("name")
+	if name == "" {
+		fmt.Println("❌ recurring carve name is required")
+		os.Exit(1)
+	}
+	env := c.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	if dbFlag {
+		e, err := envs.Get(env)
+		if err != nil {
+			return err
+		}
+		switch action {
+		case "pause":
+			err = queriesmgr.PauseRecurringCarve(name, e.ID)
+		case "resume":
+			err = queriesmgr.ResumeRecurringCarve(name, e.ID)
+		case "stop":
+			err = queriesmgr.StopRecurringCarve(name, e.ID, appName)
+		}
+		if err != nil {
+			return fmt.Errorf("error %s recurring carve %s", action, err)
+		}
+	} else if apiFlag {
+		if err := osctrlAPI.ActionRecurringCarve(env, name, action); err != nil {
+			return fmt.Errorf("error %s recurring carve %s", action, err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ recurring carve %s: %s\n", name, action)
+	}
+	return nil
+}
+
+func pauseRecurringCarve(c *cli.Context) error {
+	return actionRecurringCarve(c, "pause")
+}
+
+func resumeRecurringCarve(c *cli.Context) error {
+	return actionRecurringCarve(c, "resume")
+}
+
+func stopRecurringCarve(c *cli.Context) error {
+	return actionRecurringCarve(c, "stop")
+}
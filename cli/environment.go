@@ -1,14 +1,24 @@
 package main
 
 import (
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
+	"log"
+	"net/url"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/jmpsec/osctrl/carves"
 	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/locales"
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/jmpsec/osctrl/queries"
 	"github.com/jmpsec/osctrl/tags"
+	"github.com/jmpsec/osctrl/types"
 	"github.com/olekukonko/tablewriter"
 	"github.com/urfave/cli/v2"
 )
@@ -21,6 +31,13 @@ const (
 	optionTypeBool   = "bool"
 )
 
+// Helper to record a change of an environment in its activity, errors are only logged
+func recordEnvChange(before environments.TLSEnvironment, kind, summary string) {
+	if err := envs.RecordChange(before, kind, appName, summary); err != nil {
+		log.Printf("error recording activity - %s", err)
+	}
+}
+
 func addEnvironment(c *cli.Context) error {
 	// Get environment name
 	envName := c.String("name")
@@ -34,6 +51,11 @@ func addEnvironment(c *cli.Context) error {
 		fmt.Println("❌ Environment hostname is required")
 		os.Exit(1)
 	}
+	category := c.String("category")
+	if !environments.IsCategory(category) {
+		fmt.Printf("❌ invalid category %s\n", category)
+		os.Exit(1)
+	}
 	// Get certificate
 	var certificate string
 	certFile := c.String("certificate")
@@ -46,6 +68,7 @@ func addEnvironment(c *cli.Context) error {
 		newEnv.DebugHTTP = c.Bool("debug")
 		newEnv.Configuration = envs.GenEmptyConfiguration(true)
 		newEnv.Certificate = certificate
+		newEnv.Category = category
 		newEnv.EnrollExpire = time.Now().Add(time.Duration(environments.DefaultLinkExpire) * time.Hour)
 		newEnv.RemoveExpire = time.Now().Add(time.Duration(environments.DefaultLinkExpire) * time.Hour)
 		if err := envs.Create(newEnv); err != nil {
@@ -72,6 +95,15 @@ func addEnvironment(c *cli.Context) error {
 		if err := envs.UpdateFlags(envName, flags); err != nil {
 			return err
 		}
+		if category != "" {
+			created, err := envs.Get(envName)
+			if err != nil {
+				return err
+			}
+			if err := envs.RecordActivity(created.ID, environments.ActivityCategory, appName, "category set to "+category, "", environments.ActivityValue(created, environments.ActivityCategory)); err != nil {
+				log.Printf("error recording activity - %s", err)
+			}
+		}
 	} else {
 		fmt.Printf("Environment %s already exists!\n", envName)
 		os.Exit(1)
@@ -91,6 +123,7 @@ func updateEnvironment(c *cli.Context) error {
 	if err != nil {
 		return err
 	}
+	before := env
 	debug := c.Bool("debug")
 	env.DebugHTTP = debug
 	enroll := c.Bool("enroll")
@@ -112,10 +145,153 @@ func updateEnvironment(c *cli.Context) error {
 	if queryInterval != 0 {
 		env.QueryInterval = queryInterval
 	}
+	carveScan := c.String("carve-scan")
+	if carveScan != "" {
+		if !carves.ValidScanPolicy(carveScan) {
+			return fmt.Errorf("invalid carve scan policy %s", carveScan)
+		}
+		env.CarveScanPolicy = carveScan
+	}
 	// Update environment
 	if err := envs.Update(env); err != nil {
 		return err
 	}
+	// Storm threshold is updated on its own, so it can be disabled with 0
+	if c.IsSet("storm-threshold") {
+		env.StormThreshold = c.Int("storm-threshold")
+		if err := envs.UpdateStormThreshold(envName, env.StormThreshold); err != nil {
+			return err
+		}
+	}
+	// Enroll loops are updated on their own, so they can be reset to the defaults with 0
+	if c.IsSet("enroll-loop-limit") || c.IsSet("enroll-loop-quiet") || c.IsSet("enroll-backoff") {
+		if c.IsSet("enroll-loop-limit") {
+			env.EnrollLoopLimit = c.Int("enroll-loop-limit")
+		}
+		if c.IsSet("enroll-loop-quiet") {
+			env.EnrollLoopQuiet = c.Int("enroll-loop-quiet")
+		}
+		if c.IsSet("enroll-backoff") {
+			env.EnrollBackoff = c.Bool("enroll-backoff")
+		}
+		if err := envs.UpdateEnrollLoops(envName, env.EnrollLoopLimit, env.EnrollLoopQuiet, env.EnrollBackoff); err != nil {
+			return err
+		}
+	}
+	// Naming policy is updated on its own, names of existing nodes only change when they are normalized
+	namingChanged := c.IsSet("naming-lowercase") || c.IsSet("naming-domains") || c.IsSet("naming-prefer-fqdn")
+	if namingChanged {
+		if c.IsSet("naming-lowercase") {
+			env.NamingLowercase = c.Bool("naming-lowercase")
+		}
+		if c.IsSet("naming-domains") {
+			domains, err := nodes.ParseNamingDomains(c.String("naming-domains"))
+			if err != nil {
+				return err
+			}
+			env.NamingDomains = strings.Join(domains, ",")
+		}
+		if c.IsSet("naming-prefer-fqdn") {
+			env.NamingPreferFQDN = c.Bool("naming-prefer-fqdn")
+		}
+		if err := envs.UpdateNaming(envName, env.NamingLowercase, env.NamingDomains, env.NamingPreferFQDN); err != nil {
+			return err
+		}
+	}
+	// Identity policy is updated on its own, nodes are bound again from their enrollment if it is enabled later
+	if c.IsSet("identity-policy") {
+		identity := c.String("identity-policy")
+		if !nodes.ValidIdentityPolicy(identity) {
+			return fmt.Errorf("invalid identity policy %s", identity)
+		}
+		env.IdentityPolicy = identity
+		if err := envs.UpdateIdentityPolicy(envName, env.IdentityPolicy); err != nil {
+			return err
+		}
+		if identity == "" || identity == nodes.IdentityPolicyOff {
+			if err := nodesmgr.UnbindIdentities(env.ID); err != nil {
+				return err
+			}
+		}
+	}
+	// Locale is updated on its own, so it can be reset to the default with empty
+	if c.IsSet("locale") {
+		env.Locale = c.String("locale")
+		if err := envs.UpdateLocale(envName, env.Locale); err != nil {
+			return err
+		}
+	}
+	// Allowed platforms are updated on its own, so it can be reset to any platform with empty
+	if c.IsSet("platforms") {
+		platforms, err := environments.ParsePlatforms(c.String("platforms"))
+		if err != nil {
+			return err
+		}
+		env.AllowedPlatforms = strings.Join(platforms, ",")
+		if err := envs.UpdateAllowedPlatforms(envName, env.AllowedPlatforms); err != nil {
+			return err
+		}
+		// Existing nodes out of policy keep working, they are flagged for review
+		flagged, err := nodesmgr.FlagPlatforms(env.ID, env.AllowedPlatforms, func(platform string) bool {
+			return environments.PlatformAllowed(env.AllowedPlatforms, platform)
+		})
+		if err != nil {
+			return err
+		}
+		if flagged > 0 {
+			fmt.Printf("%d nodes with platforms not allowed were flagged\n", flagged)
+		}
+	}
+	// Pinned osquery versions are updated on their own, so the pin can be removed with empty
+	if c.IsSet("osquery-pin") || c.IsSet("osquery-pins") {
+		if c.IsSet("osquery-pin") {
+			env.OsqueryPin = strings.TrimSpace(c.String("osquery-pin"))
+		}
+		if c.IsSet("osquery-pins") {
+			pins, err := environments.ParseOsqueryPins(c.String("osquery-pins"))
+			if err != nil {
+				return err
+			}
+			env.OsqueryPins = environments.FormatOsqueryPins(pins)
+		}
+		if err := envs.UpdateOsqueryPin(envName, env.OsqueryPin, env.OsqueryPins); err != nil {
+			return err
+		}
+	}
+	// Paths of the endpoints are updated on their own, nodes keep using the old paths until they get new flags
+	pathFlags := map[string]*string{
+		"enroll-path":       &env.EnrollPath,
+		"log-path":          &env.LogPath,
+		"config-path":       &env.ConfigPath,
+		"read-path":         &env.QueryReadPath,
+		"write-path":        &env.QueryWritePath,
+		"carver-init-path":  &env.CarverInitPath,
+		"carver-block-path": &env.CarverBlockPath,
+	}
+	pathsChanged := c.Bool("random-paths")
+	if pathsChanged {
+		paths := environments.RandomPaths()
+		env.EnrollPath, env.LogPath, env.ConfigPath = paths.Enroll, paths.Log, paths.Config
+		env.QueryReadPath, env.QueryWritePath = paths.QueryRead, paths.QueryWrite
+		env.CarverInitPath, env.CarverBlockPath = paths.CarverInit, paths.CarverBlock
+	}
+	for name, field := range pathFlags {
+		if c.IsSet(name) {
+			*field = c.String(name)
+			pathsChanged = true
+		}
+	}
+	if pathsChanged {
+		if err := envs.UpdatePaths(envName, env.Paths()); err != nil {
+			return err
+		}
+	}
+	if c.IsSet("category") {
+		env.Category = c.String("category")
+		if err := envs.UpdateCategory(envName, env.Category); err != nil {
+			return err
+		}
+	}
 	// Make sure flags are up to date
 	flags, err := envs.GenerateFlags(env, "", "")
 	if err != nil {
@@ -125,7 +301,74 @@ func updateEnvironment(c *cli.Context) error {
 	if err := envs.UpdateFlags(envName, flags); err != nil {
 		return err
 	}
+	env.Flags = flags
+	for _, kind := range []string{environments.ActivityIntervals, environments.ActivityDebug, environments.ActivityFlags, environments.ActivityCarves, environments.ActivityIdentity, environments.ActivityStorm, environments.ActivityEnrollLoops, environments.ActivityNaming, environments.ActivityLocale, environments.ActivityPlatforms, environments.ActivityOsquery, environments.ActivityPaths, environments.ActivityCategory} {
+		if environments.ActivityValue(before, kind) != environments.ActivityValue(env, kind) {
+			recordEnvChange(before, kind, kind+" updated")
+		}
+	}
+	for _, w := range environments.LintFlagsEnv(env) {
+		fmt.Printf("Warning: %s\n", w)
+	}
 	fmt.Printf("Environment %s was updated successfully\n", envName)
+	if pathsChanged {
+		fmt.Println("Paths of the endpoints changed, nodes need the new flags to keep working")
+	}
+	if namingChanged {
+		report, err := nodesmgr.Normalize(env.ID, nodes.NewNamingPolicy(env.NamingLowercase, env.NamingDomains, env.NamingPreferFQDN), true, nodes.DefaultNamingBatch)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d of %d names of nodes would change with %d collisions, use normalize to apply them\n", report.Changed, report.Nodes, len(report.Collisions))
+	}
+	return nil
+}
+
+func normalizeNodeNames(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("Environment name is required")
+		os.Exit(1)
+	}
+	env, err := envs.Get(envName)
+	if err != nil {
+		return err
+	}
+	dryRun := c.Bool("dry-run")
+	report, err := nodesmgr.Normalize(env.ID, nodes.NewNamingPolicy(env.NamingLowercase, env.NamingDomains, env.NamingPreferFQDN), dryRun, nodes.DefaultNamingBatch)
+	if err != nil {
+		return err
+	}
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(report)
+		if err != nil {
+			return fmt.Errorf("error marshaling - %s", err)
+		}
+		fmt.Println(string(jsonRaw))
+		return nil
+	}
+	for _, ch := range report.Changes {
+		fmt.Printf("%s: %s -> %s\n", ch.UUID, ch.From, ch.To)
+	}
+	// Collisions are flagged, nodes with the same name are never merged
+	names := make([]string, 0, len(report.Collisions))
+	for name := range report.Collisions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("⚠️  %s is the name of %d nodes: %s\n", name, len(report.Collisions[name]), strings.Join(report.Collisions[name], ", "))
+	}
+	if dryRun {
+		fmt.Printf("%d of %d names would change, %d collisions\n", report.Changed, report.Nodes, len(report.Collisions))
+		return nil
+	}
+	summary := fmt.Sprintf("%d of %d names normalized, %d collisions", report.Changed, report.Nodes, len(report.Collisions))
+	if err := envs.RecordActivity(env.ID, environments.ActivityNaming, appName, "display names of nodes normalized: "+summary, "", ""); err != nil {
+		log.Printf("error recording activity - %s", err)
+	}
+	fmt.Printf("✅ %s\n", summary)
 	return nil
 }
 
@@ -159,6 +402,15 @@ func showEnvironment(c *cli.Context) error {
 	fmt.Printf(" RemoveExpire: %v\n", env.RemoveExpire)
 	fmt.Printf(" RemoveSecretPath: %s\n", env.RemoveSecretPath)
 	fmt.Printf(" Type: %v\n", env.Type)
+	if env.Category != "" {
+		policy, err := envs.CategoryPolicy(env.Category)
+		if err != nil {
+			return err
+		}
+		fmt.Printf(" Category: %s (%s)\n", env.Category, environments.PolicySummary(policy))
+	} else {
+		fmt.Println(" Category: none")
+	}
 	fmt.Printf(" DebugHTTP? %v\n", env.DebugHTTP)
 	fmt.Printf(" Icon: %s\n", env.Icon)
 	fmt.Printf(" Configuration Path: /%s/%s\n", env.UUID, env.ConfigPath)
@@ -170,6 +422,18 @@ func showEnvironment(c *cli.Context) error {
 	fmt.Printf(" Query Interval: %d seconds\n", env.QueryInterval)
 	fmt.Printf(" Carve Init Path: /%s/%s\n", env.UUID, env.CarverInitPath)
 	fmt.Printf(" Carve Block Path: /%s/%s\n", env.UUID, env.CarverBlockPath)
+	fmt.Printf(" Carve Scan Policy: %s\n", env.CarveScanPolicy)
+	fmt.Printf(" Identity Policy: %s\n", env.IdentityPolicy)
+	fmt.Printf(" Storm Threshold: %d requests per minute\n", env.StormThreshold)
+	loopCfg := nodes.NewEnrollLoopConfig(env.EnrollLoopLimit, env.EnrollLoopQuiet, env.EnrollBackoff)
+	fmt.Printf(" Enroll Loops: over %d enrolls per hour, cleared after %s, backoff %t\n", loopCfg.Threshold, loopCfg.Quiet, loopCfg.Backoff)
+	fmt.Printf(" Locale: %s\n", locales.Normalize(env.Locale))
+	if env.AllowedPlatforms != "" {
+		fmt.Printf(" Allowed Platforms: %s\n", env.AllowedPlatforms)
+	} else {
+		fmt.Println(" Allowed Platforms: any")
+	}
+	fmt.Printf(" Configuration Version: %d\n", env.ConfigVersion)
 	fmt.Println(" Flags: ")
 	fmt.Printf("%s\n", env.Flags)
 	fmt.Println(" Options: ")
@@ -210,11 +474,22 @@ func listEnvironment(c *cli.Context) error {
 	if err != nil {
 		return err
 	}
+	category := c.String("category")
+	if category != "" {
+		filtered := []environments.TLSEnvironment{}
+		for _, env := range envAll {
+			if env.Category == category {
+				filtered = append(filtered, env)
+			}
+		}
+		envAll = filtered
+	}
 	table := tablewriter.NewWriter(os.Stdout)
 	table.SetHeader([]string{
 		"UUID",
 		"Name",
 		"Type",
+		"Category",
 		"Hostname",
 		"DebugHTTP?",
 	})
@@ -225,6 +500,7 @@ func listEnvironment(c *cli.Context) error {
 				env.UUID,
 				env.Name,
 				env.Type,
+				env.Category,
 				env.Hostname,
 				stringifyBool(env.DebugHTTP),
 			}
@@ -238,6 +514,91 @@ func listEnvironment(c *cli.Context) error {
 	return nil
 }
 
+func listCategoryPolicies(c *cli.Context) error {
+	policies, err := envs.CategoryPolicies()
+	if err != nil {
+		return err
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{
+		"Category",
+		"Confirm Targets",
+		"Require Note?",
+		"Query Expiration",
+		"Updated By",
+	})
+	data := [][]string{}
+	for _, p := range policies {
+		data = append(data, []string{
+			p.Category,
+			strconv.Itoa(p.ConfirmTargets),
+			stringifyBool(p.RequireNote),
+			strconv.Itoa(p.QueryExpiration) + "h",
+			p.UpdatedBy,
+		})
+	}
+	table.AppendBulk(data)
+	table.Render()
+	return nil
+}
+
+func setCategoryPolicy(c *cli.Context) error {
+	category := c.String("category")
+	policy, err := envs.CategoryPolicy(category)
+	if err != nil {
+		return err
+	}
+	if c.IsSet("confirm-targets") {
+		policy.ConfirmTargets = c.Int("confirm-targets")
+	}
+	if c.IsSet("require-note") {
+		policy.RequireNote = c.Bool("require-note")
+	}
+	if c.IsSet("query-expiration") {
+		policy.QueryExpiration = c.Int("query-expiration")
+	}
+	if err := envs.SetCategoryPolicy(policy, appName); err != nil {
+		return err
+	}
+	envAll, err := envs.All()
+	if err != nil {
+		return err
+	}
+	for _, env := range envAll {
+		if env.Category == category {
+			if err := envs.RecordActivity(env.ID, environments.ActivityCategory, appName, environments.PolicySummary(policy), "", ""); err != nil {
+				log.Printf("error recording activity - %s", err)
+			}
+		}
+	}
+	fmt.Printf("✅ %s\n", environments.PolicySummary(policy))
+	return nil
+}
+
+// Helper to check if a launch in an environment is confirmed as the policy of its category asks,
+// counting launches by time window or attributes as all the active nodes of the environment
+func checkLaunch(e environments.TLSEnvironment, uuid string, broad bool, typed string) error {
+	policy, err := envs.CategoryPolicy(e.Category)
+	if err != nil {
+		return err
+	}
+	if policy.ConfirmTargets <= 0 {
+		return nil
+	}
+	targets := 0
+	if uuid != "" {
+		targets = 1
+	}
+	if broad {
+		active, err := nodesmgr.GetByEnv(e.Name, "active", settingsmgr.InactiveHours())
+		if err != nil {
+			return err
+		}
+		targets = len(active)
+	}
+	return policy.CheckLaunch(e, targets, typed)
+}
+
 func quickAddEnvironment(c *cli.Context) error {
 	// Get environment name
 	envName := c.String("name")
@@ -332,9 +693,14 @@ func addScheduledQuery(c *cli.Context) error {
 		Platform: c.String("platform"),
 		Version:  c.String("version"),
 	}
+	before, err := envs.Get(envName)
+	if err != nil {
+		return fmt.Errorf("error getting environment - %s", err)
+	}
 	if err := envs.AddScheduleConfQuery(envName, queryName, qData); err != nil {
 		return err
 	}
+	recordEnvChange(before, environments.ActivitySchedule, fmt.Sprintf("scheduled query %s added", queryName))
 	fmt.Printf("Query %s was created successfully\n", queryName)
 	return nil
 }
@@ -353,9 +719,14 @@ func removeScheduledQuery(c *cli.Context) error {
 		os.Exit(1)
 	}
 	// Remove query
+	before, err := envs.Get(envName)
+	if err != nil {
+		return fmt.Errorf("error getting environment - %s", err)
+	}
 	if err := envs.RemoveScheduleConfQuery(envName, queryName); err != nil {
 		return err
 	}
+	recordEnvChange(before, environments.ActivitySchedule, fmt.Sprintf("scheduled query %s removed", queryName))
 	fmt.Printf("Query %s was removed successfully\n", queryName)
 	return nil
 }
@@ -393,9 +764,14 @@ func addOsqueryOption(c *cli.Context) error {
 		os.Exit(1)
 	}
 	// Add osquery option
+	before, err := envs.Get(envName)
+	if err != nil {
+		return fmt.Errorf("error getting environment - %s", err)
+	}
 	if err := envs.AddOptionsConf(envName, option, optionValue); err != nil {
 		return err
 	}
+	recordEnvChange(before, environments.ActivityOptions, fmt.Sprintf("option %s added", option))
 	fmt.Printf("Option %s was added successfully\n", option)
 	return nil
 }
@@ -414,9 +790,14 @@ func removeOsqueryOption(c *cli.Context) error {
 		os.Exit(1)
 	}
 	// Remove osquery option
+	before, err := envs.Get(envName)
+	if err != nil {
+		return fmt.Errorf("error getting environment - %s", err)
+	}
 	if err := envs.RemoveOptionsConf(envName, option); err != nil {
 		return err
 	}
+	recordEnvChange(before, environments.ActivityOptions, fmt.Sprintf("option %s removed", option))
 	fmt.Printf("Option %s was added successfully\n", option)
 	return nil
 }
@@ -441,9 +822,14 @@ func addNewPack(c *cli.Context) error {
 		Shard:    json.Number(strconv.Itoa(c.Int("shard"))),
 	}
 	// Add pack to configuration
+	before, err := envs.Get(envName)
+	if err != nil {
+		return fmt.Errorf("error getting environment - %s", err)
+	}
 	if err := envs.AddQueryPackConf(envName, pName, pack); err != nil {
 		return err
 	}
+	recordEnvChange(before, environments.ActivityPacks, fmt.Sprintf("pack %s added", pName))
 	fmt.Printf("Pack %s was added successfully\n", pName)
 	return nil
 }
@@ -462,9 +848,14 @@ func removePack(c *cli.Context) error {
 		os.Exit(1)
 	}
 	// Remove pack from configuration
+	before, err := envs.Get(envName)
+	if err != nil {
+		return fmt.Errorf("error getting environment - %s", err)
+	}
 	if err := envs.RemoveQueryPackConf(envName, pName); err != nil {
 		return err
 	}
+	recordEnvChange(before, environments.ActivityPacks, fmt.Sprintf("pack %s removed", pName))
 	fmt.Printf("Pack %s was added successfully\n", pName)
 	return nil
 }
@@ -489,9 +880,14 @@ func addLocalPack(c *cli.Context) error {
 		os.Exit(1)
 	}
 	// Add pack to configuration option
+	before, err := envs.Get(envName)
+	if err != nil {
+		return fmt.Errorf("error getting environment - %s", err)
+	}
 	if err := envs.AddQueryPackConf(envName, pName, pPath); err != nil {
 		return err
 	}
+	recordEnvChange(before, environments.ActivityPacks, fmt.Sprintf("local pack %s added", pName))
 	fmt.Printf("Pack %s was added successfully\n", pName)
 	return nil
 }
@@ -534,9 +930,14 @@ func addPackQuery(c *cli.Context) error {
 		Platform: c.String("platform"),
 		Version:  c.String("version"),
 	}
+	before, err := envs.Get(envName)
+	if err != nil {
+		return fmt.Errorf("error getting environment - %s", err)
+	}
 	if err := envs.AddQueryToPackConf(envName, packName, queryName, qData); err != nil {
 		return err
 	}
+	recordEnvChange(before, environments.ActivityPacks, fmt.Sprintf("query %s added to pack %s", queryName, packName))
 	fmt.Printf("Query %s was added to pack %s successfully\n", queryName, packName)
 	return nil
 }
@@ -561,9 +962,1594 @@ func removePackQuery(c *cli.Context) error {
 		os.Exit(1)
 	}
 	// Remove query
+	before, err := envs.Get(envName)
+	if err != nil {
+		return fmt.Errorf("error getting environment - %s", err)
+	}
 	if err := envs.RemoveQueryFromPackConf(envName, packName, queryName); err != nil {
 		return err
 	}
+	recordEnvChange(before, environments.ActivityPacks, fmt.Sprintf("query %s removed from pack %s", queryName, packName))
 	fmt.Printf("Query %s was removed from pack %s successfully\n", queryName, packName)
 	return nil
 }
+
+func certificateEnvironment(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ Environment name is required")
+		os.Exit(1)
+	}
+	certFile := c.String("certificate")
+	if certFile == "" {
+		fmt.Println("❌ Certificate file is required")
+		os.Exit(1)
+	}
+	certificate := environments.ReadExternalFile(certFile)
+	var activateAt time.Time
+	if t := c.Timestamp("activate"); t != nil {
+		activateAt = *t
+	}
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		cert, err := envs.StageCertificate(env, certificate, activateAt)
+		if err != nil {
+			return fmt.Errorf("error staging certificate - %s", err)
+		}
+		summary := fmt.Sprintf("certificate version %d staged to activate at %s", cert.Version, cert.ActivateAt)
+		if err := envs.RecordActivity(env.ID, environments.ActivityCertificate, appName, summary, env.Certificate, certificate); err != nil {
+			log.Printf("error recording activity - %s", err)
+		}
+		fmt.Printf("✅ Certificate version %d for %s activates at %s\n", cert.Version, envName, cert.ActivateAt)
+	} else if apiFlag {
+		r, err := osctrlAPI.StageCertificate(envName, certificate, activateAt)
+		if err != nil {
+			return fmt.Errorf("error staging certificate - %s", err)
+		}
+		fmt.Printf("✅ %s\n", r.Message)
+	}
+	return nil
+}
+
+func certificateStatusEnvironment(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ Environment name is required")
+		os.Exit(1)
+	}
+	var status environments.CertificateStatus
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		stats, err := nodesmgr.GetStatsByEnv(env.Name, settingsmgr.InactiveHours())
+		if err != nil {
+			return fmt.Errorf("error getting nodes - %s", err)
+		}
+		status, err = envs.GetCertificateStatus(env, stats.Total)
+		if err != nil {
+			return fmt.Errorf("error getting certificate status - %s", err)
+		}
+	} else if apiFlag {
+		status, err = osctrlAPI.GetCertificateStatus(envName)
+		if err != nil {
+			return fmt.Errorf("error getting certificate status - %s", err)
+		}
+	}
+	// Prepare output
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(status)
+		if err != nil {
+			return fmt.Errorf("error marshaling - %s", err)
+		}
+		fmt.Println(string(jsonRaw))
+		return nil
+	}
+	header := []string{
+		"Version",
+		"Nodes",
+		"State",
+	}
+	versions := make([]int, 0, len(status.Versions))
+	for v := range status.Versions {
+		versions = append(versions, v)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+	data := [][]string{}
+	for _, v := range versions {
+		state := ""
+		if v == status.ActiveVersion {
+			state = "active"
+		}
+		data = append(data, []string{strconv.Itoa(v), strconv.FormatInt(status.Versions[v], 10), state})
+	}
+	data = append(data, []string{"unknown", strconv.FormatInt(status.Unknown, 10), ""})
+	if formatFlag == csvFormat {
+		w := csv.NewWriter(os.Stdout)
+		if err := w.WriteAll(append([][]string{header}, data...)); err != nil {
+			return fmt.Errorf("error writting csv - %s", err)
+		}
+	} else if formatFlag == prettyFormat {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader(header)
+		table.AppendBulk(data)
+		table.Render()
+		fmt.Printf("Active version %d retrieved by %.1f%% of %d nodes\n", status.ActiveVersion, status.Propagation, status.Nodes)
+		if status.StagedVersion != 0 {
+			fmt.Printf("Version %d staged to activate at %s\n", status.StagedVersion, status.StagedAt)
+		}
+	}
+	return nil
+}
+
+func listEnrollFields(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ Environment name is required")
+		os.Exit(1)
+	}
+	var fields []environments.EnrollField
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		fields, err = envs.EnrollFields(env.ID)
+		if err != nil {
+			return fmt.Errorf("error getting enroll fields - %s", err)
+		}
+	} else if apiFlag {
+		fields, err = osctrlAPI.GetEnrollFields(envName)
+		if err != nil {
+			return fmt.Errorf("error getting enroll fields - %s", err)
+		}
+	}
+	header := []string{
+		"Name",
+		"Description",
+		"Regex",
+		"Required",
+	}
+	data := [][]string{}
+	for _, f := range fields {
+		data = append(data, []string{f.Name, f.Description, f.Regex, stringifyBool(f.Required)})
+	}
+	// Prepare output
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(fields)
+		if err != nil {
+			return fmt.Errorf("error marshaling - %s", err)
+		}
+		fmt.Println(string(jsonRaw))
+	} else if formatFlag == csvFormat {
+		w := csv.NewWriter(os.Stdout)
+		if err := w.WriteAll(append([][]string{header}, data...)); err != nil {
+			return fmt.Errorf("error writting csv - %s", err)
+		}
+	} else if formatFlag == prettyFormat {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader(header)
+		if len(fields) > 0 {
+			fmt.Printf("Existing enroll fields in %s (%d):\n", envName, len(fields))
+			table.AppendBulk(data)
+		} else {
+			fmt.Printf("No enroll fields in %s\n", envName)
+		}
+		table.Render()
+	}
+	return nil
+}
+
+func setEnrollField(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ Environment name is required")
+		os.Exit(1)
+	}
+	field := c.String("field")
+	if field == "" {
+		fmt.Println("❌ Field name is required")
+		os.Exit(1)
+	}
+	f := types.ApiEnrollFieldRequest{
+		Name:        field,
+		Description: c.String("description"),
+		Regex:       c.String("regex"),
+		Required:    c.Bool("required"),
+	}
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		if _, err := envs.SetEnrollField(env.ID, environments.EnrollField{
+			Name:        f.Name,
+			Description: f.Description,
+			Regex:       f.Regex,
+			Required:    f.Required,
+		}); err != nil {
+			return fmt.Errorf("error saving enroll field - %s", err)
+		}
+		if err := envs.RecordActivity(env.ID, environments.ActivityEnrollFields, appName, "enroll field "+field+" saved", "", ""); err != nil {
+			log.Printf("error recording activity - %s", err)
+		}
+	} else if apiFlag {
+		if _, err := osctrlAPI.SetEnrollField(envName, f); err != nil {
+			return fmt.Errorf("error saving enroll field - %s", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ Enroll field %s saved in %s\n", field, envName)
+	}
+	return nil
+}
+
+func deleteEnrollField(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ Environment name is required")
+		os.Exit(1)
+	}
+	field := c.String("field")
+	if field == "" {
+		fmt.Println("❌ Field name is required")
+		os.Exit(1)
+	}
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		if err := envs.DeleteEnrollField(env.ID, field); err != nil {
+			return fmt.Errorf("error deleting enroll field - %s", err)
+		}
+		if err := envs.RecordActivity(env.ID, environments.ActivityEnrollFields, appName, "enroll field "+field+" deleted", "", ""); err != nil {
+			log.Printf("error recording activity - %s", err)
+		}
+	} else if apiFlag {
+		if _, err := osctrlAPI.DeleteEnrollField(envName, field); err != nil {
+			return fmt.Errorf("error deleting enroll field - %s", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ Enroll field %s deleted from %s\n", field, envName)
+	}
+	return nil
+}
+
+func listProfiles(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ Environment name is required")
+		os.Exit(1)
+	}
+	var profiles []environments.PerformanceProfile
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		profiles, err = envs.Profiles(env.ID)
+		if err != nil {
+			return fmt.Errorf("error getting profiles - %s", err)
+		}
+	} else if apiFlag {
+		profiles, err = osctrlAPI.GetProfiles(envName)
+		if err != nil {
+			return fmt.Errorf("error getting profiles - %s", err)
+		}
+	}
+	header := []string{
+		"Name",
+		"Priority",
+		"Tag",
+		"Platform",
+		"Hardware Model",
+		"Config Refresh",
+		"Logger Period",
+		"Query Interval",
+	}
+	data := [][]string{}
+	for _, p := range profiles {
+		data = append(data, []string{
+			p.Name,
+			strconv.Itoa(p.Priority),
+			p.Tag,
+			p.Platform,
+			p.HardwareModel,
+			strconv.Itoa(p.ConfigInterval),
+			strconv.Itoa(p.LogInterval),
+			strconv.Itoa(p.QueryInterval),
+		})
+	}
+	// Prepare output
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(profiles)
+		if err != nil {
+			return fmt.Errorf("error marshaling - %s", err)
+		}
+		fmt.Println(string(jsonRaw))
+	} else if formatFlag == csvFormat {
+		w := csv.NewWriter(os.Stdout)
+		if err := w.WriteAll(append([][]string{header}, data...)); err != nil {
+			return fmt.Errorf("error writting csv - %s", err)
+		}
+	} else if formatFlag == prettyFormat {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader(header)
+		if len(profiles) > 0 {
+			fmt.Printf("Existing performance profiles in %s (%d):\n", envName, len(profiles))
+			table.AppendBulk(data)
+		} else {
+			fmt.Printf("No performance profiles in %s\n", envName)
+		}
+		table.Render()
+	}
+	return nil
+}
+
+func setProfile(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ Environment name is required")
+		os.Exit(1)
+	}
+	profile := c.String("profile")
+	if profile == "" {
+		fmt.Println("❌ Profile name is required")
+		os.Exit(1)
+	}
+	p := types.ApiProfileRequest{
+		Name:           profile,
+		Priority:       c.Int("priority"),
+		Tag:            c.String("tag"),
+		Platform:       c.String("platform"),
+		HardwareModel:  c.String("hardware-model"),
+		ConfigInterval: c.Int("config"),
+		LogInterval:    c.Int("log"),
+		QueryInterval:  c.Int("query"),
+	}
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		if _, err := envs.SetProfile(env.ID, environments.PerformanceProfile{
+			Name:           p.Name,
+			Priority:       p.Priority,
+			Tag:            p.Tag,
+			Platform:       p.Platform,
+			HardwareModel:  p.HardwareModel,
+			ConfigInterval: p.ConfigInterval,
+			LogInterval:    p.LogInterval,
+			QueryInterval:  p.QueryInterval,
+		}); err != nil {
+			return fmt.Errorf("error saving profile - %s", err)
+		}
+		if err := envs.RecordActivity(env.ID, environments.ActivityProfiles, appName, "performance profile "+profile+" saved", "", ""); err != nil {
+			log.Printf("error recording activity - %s", err)
+		}
+	} else if apiFlag {
+		if _, err := osctrlAPI.SetProfile(envName, p); err != nil {
+			return fmt.Errorf("error saving profile - %s", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ Performance profile %s saved in %s\n", profile, envName)
+	}
+	return nil
+}
+
+func deleteProfile(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ Environment name is required")
+		os.Exit(1)
+	}
+	profile := c.String("profile")
+	if profile == "" {
+		fmt.Println("❌ Profile name is required")
+		os.Exit(1)
+	}
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		if err := envs.DeleteProfile(env.ID, profile); err != nil {
+			return fmt.Errorf("error deleting profile - %s", err)
+		}
+		if err := envs.RecordActivity(env.ID, environments.ActivityProfiles, appName, "performance profile "+profile+" deleted", "", ""); err != nil {
+			log.Printf("error recording activity - %s", err)
+		}
+	} else if apiFlag {
+		if _, err := osctrlAPI.DeleteProfile(envName, profile); err != nil {
+			return fmt.Errorf("error deleting profile - %s", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ Performance profile %s deleted from %s\n", profile, envName)
+	}
+	return nil
+}
+
+func listDenylist(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ Environment name is required")
+		os.Exit(1)
+	}
+	var denylist []environments.ScheduleDeny
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		denylist, err = envs.ScheduleDenylist(env.ID)
+		if err != nil {
+			return fmt.Errorf("error getting denylist - %s", err)
+		}
+	} else if apiFlag {
+		denylist, err = osctrlAPI.GetDenylist(envName)
+		if err != nil {
+			return fmt.Errorf("error getting denylist - %s", err)
+		}
+	}
+	header := []string{
+		"ID",
+		"Pack",
+		"Query",
+		"Platform",
+		"Tag",
+		"Reason",
+		"Added By",
+		"Expires",
+	}
+	data := [][]string{}
+	for _, d := range denylist {
+		expires := "never"
+		if !d.ExpiresAt.IsZero() {
+			expires = d.ExpiresAt.Format(time.RFC3339)
+		}
+		data = append(data, []string{
+			strconv.Itoa(int(d.ID)),
+			d.Pack,
+			d.Query,
+			d.Platform,
+			d.Tag,
+			d.Reason,
+			d.Creator,
+			expires,
+		})
+	}
+	// Prepare output
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(denylist)
+		if err != nil {
+			return fmt.Errorf("error marshaling - %s", err)
+		}
+		fmt.Println(string(jsonRaw))
+	} else if formatFlag == csvFormat {
+		w := csv.NewWriter(os.Stdout)
+		if err := w.WriteAll(append([][]string{header}, data...)); err != nil {
+			return fmt.Errorf("error writting csv - %s", err)
+		}
+	} else if formatFlag == prettyFormat {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader(header)
+		if len(denylist) > 0 {
+			fmt.Printf("Active denylist entries in %s (%d):\n", envName, len(denylist))
+			table.AppendBulk(data)
+		} else {
+			fmt.Printf("No denylist entries in %s\n", envName)
+		}
+		table.Render()
+	}
+	return nil
+}
+
+func addDeny(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ Environment name is required")
+		os.Exit(1)
+	}
+	if c.String("pack") == "" && c.String("query") == "" {
+		fmt.Println("❌ Pack or query is required")
+		os.Exit(1)
+	}
+	reason := c.String("reason")
+	if reason == "" {
+		fmt.Println("❌ Reason is required")
+		os.Exit(1)
+	}
+	d := types.ApiDenylistRequest{
+		Pack:     c.String("pack"),
+		Query:    c.String("query"),
+		Platform: c.String("platform"),
+		Tag:      c.String("tag"),
+		Reason:   reason,
+		Hours:    c.Int("hours"),
+	}
+	var target string
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		entry := environments.ScheduleDeny{
+			Pack:     d.Pack,
+			Query:    d.Query,
+			Platform: d.Platform,
+			Tag:      d.Tag,
+			Reason:   d.Reason,
+			Creator:  appName,
+		}
+		if d.Hours > 0 {
+			entry.ExpiresAt = time.Now().Add(time.Duration(d.Hours) * time.Hour)
+		}
+		saved, err := envs.AddScheduleDeny(env.ID, entry)
+		if err != nil {
+			return fmt.Errorf("error adding to denylist - %s", err)
+		}
+		if err := envs.RecordActivity(env.ID, environments.ActivityDenylist, appName, "added to denylist "+saved.Summary(), "", ""); err != nil {
+			log.Printf("error recording activity - %s", err)
+		}
+		target = saved.Target()
+	} else if apiFlag {
+		saved, err := osctrlAPI.AddDeny(envName, d)
+		if err != nil {
+			return fmt.Errorf("error adding to denylist - %s", err)
+		}
+		target = saved.Target()
+	}
+	if !silentFlag {
+		fmt.Printf("✅ Denied %s in %s\n", target, envName)
+	}
+	return nil
+}
+
+func liftDeny(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ Environment name is required")
+		os.Exit(1)
+	}
+	id := c.Uint("id")
+	if id == 0 {
+		fmt.Println("❌ Denylist entry ID is required")
+		os.Exit(1)
+	}
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		entry, err := envs.GetScheduleDeny(env.ID, id)
+		if err != nil {
+			return fmt.Errorf("error getting denylist entry - %s", err)
+		}
+		if err := envs.DeleteScheduleDeny(env.ID, id); err != nil {
+			return fmt.Errorf("error lifting denylist entry - %s", err)
+		}
+		if err := envs.RecordActivity(env.ID, environments.ActivityDenylist, appName, "lifted from denylist "+entry.Target(), "", ""); err != nil {
+			log.Printf("error recording activity - %s", err)
+		}
+	} else if apiFlag {
+		if _, err := osctrlAPI.LiftDeny(envName, id); err != nil {
+			return fmt.Errorf("error lifting denylist entry - %s", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ Denylist entry %d lifted in %s\n", id, envName)
+	}
+	return nil
+}
+
+func activityEnvironment(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ Environment name is required")
+		os.Exit(1)
+	}
+	filter := url.Values{}
+	filter.Set("from", c.String("since"))
+	for _, k := range c.StringSlice("kind") {
+		filter.Add("kind", k)
+	}
+	if actor := c.String("actor"); actor != "" {
+		filter.Set("actor", actor)
+	}
+	filter.Set("page", strconv.Itoa(c.Int("page")))
+	filter.Set("limit", strconv.Itoa(c.Int("limit")))
+	var activity types.ApiActivityResponse
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		f, err := environments.ParseActivityFilter(filter, time.Now())
+		if err != nil {
+			return fmt.Errorf("error parsing filter - %s", err)
+		}
+		entries, total, err := envs.Activity(env.ID, f)
+		if err != nil {
+			return fmt.Errorf("error getting activity - %s", err)
+		}
+		activity = types.ApiActivityResponse{Total: total, Page: f.Page, Limit: f.Limit, Entries: []types.ApiActivityEntry{}}
+		for _, a := range entries {
+			activity.Entries = append(activity.Entries, types.ApiActivityEntry{
+				ID:        a.ID,
+				CreatedAt: a.CreatedAt,
+				Kind:      a.Kind,
+				Actor:     a.Actor,
+				Summary:   a.Summary,
+				Changed:   a.Changed,
+			})
+		}
+	} else if apiFlag {
+		activity, err = osctrlAPI.GetActivity(envName, filter)
+		if err != nil {
+			return fmt.Errorf("error getting activity - %s", err)
+		}
+	}
+	header := []string{
+		"ID",
+		"Time",
+		"Kind",
+		"Actor",
+		"Summary",
+		"Changed",
+	}
+	data := [][]string{}
+	for _, a := range activity.Entries {
+		data = append(data, []string{
+			strconv.FormatUint(uint64(a.ID), 10),
+			a.CreatedAt.Format(time.RFC3339),
+			a.Kind,
+			a.Actor,
+			a.Summary,
+			stringifyBool(a.Changed),
+		})
+	}
+	// Prepare output
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(activity)
+		if err != nil {
+			return fmt.Errorf("error marshaling - %s", err)
+		}
+		fmt.Println(string(jsonRaw))
+	} else if formatFlag == csvFormat {
+		w := csv.NewWriter(os.Stdout)
+		if err := w.WriteAll(append([][]string{header}, data...)); err != nil {
+			return fmt.Errorf("error writting csv - %s", err)
+		}
+	} else if formatFlag == prettyFormat {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader(header)
+		if len(activity.Entries) > 0 {
+			fmt.Printf("Activity in %s, page %d (%d changes):\n", envName, activity.Page, activity.Total)
+			table.AppendBulk(data)
+		} else {
+			fmt.Printf("No activity in %s\n", envName)
+		}
+		table.Render()
+	}
+	return nil
+}
+
+func historyEnvironment(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ Environment name is required")
+		os.Exit(1)
+	}
+	metric := c.String("metric")
+	if !nodes.ValidHistoryMetric(metric) {
+		return fmt.Errorf("invalid metric %s", metric)
+	}
+	var history []nodes.NodeCountSnapshot
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		now := time.Now()
+		from, err := environments.ParseSince(c.String("since"), now)
+		if err != nil {
+			return fmt.Errorf("error parsing since - %s", err)
+		}
+		history, err = nodesmgr.CountHistory(env.ID, metric, from, now)
+		if err != nil {
+			return fmt.Errorf("error getting history - %s", err)
+		}
+	} else if apiFlag {
+		filter := url.Values{}
+		filter.Set("metric", metric)
+		filter.Set("from", c.String("since"))
+		history, err = osctrlAPI.GetHistory(envName, filter)
+		if err != nil {
+			return fmt.Errorf("error getting history - %s", err)
+		}
+	}
+	header := []string{
+		"Day",
+		"Metric",
+		"Count",
+	}
+	data := [][]string{}
+	for _, h := range history {
+		data = append(data, []string{
+			h.Day.Format("2006-01-02"),
+			h.Metric,
+			strconv.FormatInt(h.Count, 10),
+		})
+	}
+	// Prepare output
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(history)
+		if err != nil {
+			return fmt.Errorf("error marshaling - %s", err)
+		}
+		fmt.Println(string(jsonRaw))
+	} else if formatFlag == csvFormat {
+		w := csv.NewWriter(os.Stdout)
+		if err := w.WriteAll(append([][]string{header}, data...)); err != nil {
+			return fmt.Errorf("error writting csv - %s", err)
+		}
+	} else if formatFlag == prettyFormat {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader(header)
+		if len(history) > 0 {
+			fmt.Printf("History of %s nodes in %s (%d days):\n", metric, envName, len(history))
+			table.AppendBulk(data)
+		} else {
+			fmt.Printf("No history of %s nodes in %s\n", metric, envName)
+		}
+		table.Render()
+	}
+	return nil
+}
+
+func storageEnvironment(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ Environment name is required")
+		os.Exit(1)
+	}
+	var report environments.StorageReport
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		now := time.Now()
+		from, err := environments.ParseSince(c.String("since"), now)
+		if err != nil {
+			return fmt.Errorf("error parsing since - %s", err)
+		}
+		report, err = envs.StorageReport(env, from, now)
+		if err != nil {
+			return fmt.Errorf("error getting storage - %s", err)
+		}
+	} else if apiFlag {
+		filter := url.Values{}
+		filter.Set("from", c.String("since"))
+		report, err = osctrlAPI.GetEnvStorage(envName, filter)
+		if err != nil {
+			return fmt.Errorf("error getting storage - %s", err)
+		}
+	}
+	header := []string{
+		"Day",
+		"Category",
+		"Rows",
+		"Size",
+	}
+	data := [][]string{}
+	for _, s := range report.History {
+		data = append(data, []string{
+			s.Day.Format("2006-01-02"),
+			s.Category,
+			strconv.FormatInt(s.Rows, 10),
+			s.Size(),
+		})
+	}
+	// Prepare output
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(report)
+		if err != nil {
+			return fmt.Errorf("error marshaling - %s", err)
+		}
+		fmt.Println(string(jsonRaw))
+	} else if formatFlag == csvFormat {
+		w := csv.NewWriter(os.Stdout)
+		if err := w.WriteAll(append([][]string{header}, data...)); err != nil {
+			return fmt.Errorf("error writting csv - %s", err)
+		}
+	} else if formatFlag == prettyFormat {
+		if report.Day.IsZero() {
+			fmt.Printf("Storage of %s has not been measured yet\n", envName)
+			return nil
+		}
+		fmt.Printf("Storage of %s on %s: %s in %d rows\n", envName, report.Day.Format("2006-01-02"), report.Total.Size(), report.Total.Rows)
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader(header)
+		for _, category := range environments.StorageCategories {
+			u := report.Categories[category]
+			table.Append([]string{report.Day.Format("2006-01-02"), category, strconv.FormatInt(u.Rows, 10), u.Size()})
+		}
+		table.Render()
+		if report.Quota.MaxBytes > 0 {
+			status := "ok"
+			if report.Quota.Exceeded {
+				status = "exceeded since " + report.Quota.Since.Format(time.RFC3339)
+			}
+			fmt.Printf("Quota of %s (%d%%), %s, actions: %s\n", report.Quota.MaxSize(), report.Quota.Percent(), status, report.Quota.Actions)
+		}
+	}
+	return nil
+}
+
+func quotaEnvironment(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ Environment name is required")
+		os.Exit(1)
+	}
+	maxBytes, err := environments.ParseStorageSize(c.String("quota"))
+	if err != nil {
+		return fmt.Errorf("error parsing quota - %s", err)
+	}
+	actions := c.StringSlice("action")
+	var quota environments.StorageQuota
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		quota, err = envs.SetStorageQuota(env.ID, maxBytes, strings.Join(actions, ","), appName)
+		if err != nil {
+			return fmt.Errorf("error setting storage quota - %s", err)
+		}
+		if err := envs.RecordActivity(env.ID, environments.ActivityStorage, appName, "storage quota updated", "", ""); err != nil {
+			log.Printf("error recording activity - %s", err)
+		}
+	} else if apiFlag {
+		quota, err = osctrlAPI.SetStorageQuota(envName, types.ApiStorageQuotaRequest{
+			MaxBytes: maxBytes,
+			Actions:  actions,
+		})
+		if err != nil {
+			return fmt.Errorf("error setting storage quota - %s", err)
+		}
+	}
+	if !silentFlag {
+		if quota.MaxBytes > 0 {
+			fmt.Printf("✅ Storage quota of %s set to %s\n", envName, quota.MaxSize())
+		} else {
+			fmt.Printf("✅ Storage quota of %s removed\n", envName)
+		}
+	}
+	return nil
+}
+
+func coverageEnvironment(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ Environment name is required")
+		os.Exit(1)
+	}
+	var estimate nodes.CoverageEstimate
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		hours, err := queries.ParseExpiration(c.String("window"))
+		if err != nil {
+			return fmt.Errorf("error parsing window - %s", err)
+		}
+		if hours == 0 {
+			hours = queries.NewExpirationRange(int64(env.QueryExpiration), settingsmgr.QueryExpirationMinHours(), settingsmgr.QueryExpirationMaxHours()).Default
+		}
+		all, err := nodesmgr.GetByEnv(env.Name, "all", 0)
+		if err != nil {
+			return fmt.Errorf("error getting nodes - %s", err)
+		}
+		targets := nodes.CoverageTargets(all, c.StringSlice("target"), c.StringSlice("platform"))
+		estimate = nodes.EstimateCoverage(targets, time.Now(), time.Duration(hours)*time.Hour)
+		estimate.Environment = env.Name
+	} else if apiFlag {
+		filter := url.Values{}
+		filter.Set("window", c.String("window"))
+		filter.Set("targets", strings.Join(c.StringSlice("target"), ","))
+		filter.Set("platforms", strings.Join(c.StringSlice("platform"), ","))
+		estimate, err = osctrlAPI.GetCoverageEstimate(envName, filter)
+		if err != nil {
+			return fmt.Errorf("error getting coverage estimate - %s", err)
+		}
+	}
+	// Prepare output
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(estimate)
+		if err != nil {
+			return fmt.Errorf("error marshaling - %s", err)
+		}
+		fmt.Println(string(jsonRaw))
+	} else if formatFlag == csvFormat {
+		w := csv.NewWriter(os.Stdout)
+		data := [][]string{
+			{"Window", "Targets", "With History", "Expected", "Percent"},
+			{estimate.Window, strconv.Itoa(estimate.Targets), strconv.Itoa(estimate.WithHistory), strconv.FormatFloat(estimate.Expected, 'f', 1, 64), strconv.Itoa(estimate.Percent)},
+		}
+		if err := w.WriteAll(data); err != nil {
+			return fmt.Errorf("error writting csv - %s", err)
+		}
+	} else if formatFlag == prettyFormat {
+		fmt.Printf("Coverage of %s: %s\n", envName, estimate.Summary)
+	}
+	return nil
+}
+
+func enrollLoopsEnvironment(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ Environment name is required")
+		os.Exit(1)
+	}
+	var report nodes.EnrollLoopReport
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		cfg := nodes.NewEnrollLoopConfig(env.EnrollLoopLimit, env.EnrollLoopQuiet, env.EnrollBackoff)
+		report, err = nodesmgr.EnrollLoopReport(env.ID, cfg, time.Now())
+		if err != nil {
+			return fmt.Errorf("error getting enroll loops - %s", err)
+		}
+	} else if apiFlag {
+		report, err = osctrlAPI.GetEnrollLoops(envName)
+		if err != nil {
+			return fmt.Errorf("error getting enroll loops - %s", err)
+		}
+	}
+	header := []string{"UUID", "Hostname", "Looping", "Enrolls", "Last Enroll", "Blocked Until", "Hints"}
+	var data [][]string
+	for _, l := range report.Loops {
+		blocked := ""
+		if !l.BlockedUntil.IsZero() {
+			blocked = l.BlockedUntil.UTC().Format(time.RFC3339)
+		}
+		data = append(data, []string{
+			l.UUID,
+			l.Hostname,
+			strconv.FormatBool(l.Looping),
+			strconv.Itoa(l.Enrolls),
+			l.LastEnroll.UTC().Format(time.RFC3339),
+			blocked,
+			strings.Join(l.Hints, "; "),
+		})
+	}
+	// Prepare output
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(report)
+		if err != nil {
+			return fmt.Errorf("error marshaling - %s", err)
+		}
+		fmt.Println(string(jsonRaw))
+	} else if formatFlag == csvFormat {
+		w := csv.NewWriter(os.Stdout)
+		if err := w.WriteAll(append([][]string{header}, data...)); err != nil {
+			return fmt.Errorf("error writting csv - %s", err)
+		}
+	} else if formatFlag == prettyFormat {
+		fmt.Printf("Enroll loops of %s: %d looping, over %d enrolls per hour\n", envName, report.Looping, report.Threshold)
+		if len(data) == 0 {
+			return nil
+		}
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader(header)
+		table.AppendBulk(data)
+		table.Render()
+	}
+	return nil
+}
+
+func queryExpirationEnvironment(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ Environment name is required")
+		os.Exit(1)
+	}
+	hours := c.Int("hours")
+	var expiration queries.ExpirationRange
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		limits := queries.NewExpirationRange(int64(hours), settingsmgr.QueryExpirationMinHours(), settingsmgr.QueryExpirationMaxHours())
+		if hours != 0 {
+			if _, err := limits.Hours(int64(hours)); err != nil {
+				return err
+			}
+		}
+		if err := envs.UpdateQueryExpiration(env.Name, hours); err != nil {
+			return fmt.Errorf("error setting query expiration - %s", err)
+		}
+		if err := envs.RecordActivity(env.ID, environments.ActivityQueries, appName, fmt.Sprintf("default query expiration set to %dh", hours), "", ""); err != nil {
+			log.Printf("error recording activity - %s", err)
+		}
+		expiration = limits
+	} else if apiFlag {
+		expiration, err = osctrlAPI.SetQueryExpiration(envName, hours)
+		if err != nil {
+			return fmt.Errorf("error setting query expiration - %s", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ Queries of %s expire after %s by default\n", envName, nodes.FormatWindow(expiration.Default))
+	}
+	return nil
+}
+
+func pseudonymsEnvironment(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ Environment name is required")
+		os.Exit(1)
+	}
+	columns := c.String("columns")
+	tables := c.String("tables")
+	if err := environments.ValidatePseudonymPatterns(columns + "," + tables); err != nil {
+		return err
+	}
+	var cfg environments.PseudonymConfig
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		if err := envs.UpdatePseudonyms(env.Name, columns, tables); err != nil {
+			return fmt.Errorf("error setting pseudonyms - %s", err)
+		}
+		updated, err := envs.Get(env.Name)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		if err := envs.RecordActivity(env.ID, environments.ActivityPseudonyms, appName, "pseudonymized columns and tables updated",
+			environments.ActivityValue(env, environments.ActivityPseudonyms), environments.ActivityValue(updated, environments.ActivityPseudonyms)); err != nil {
+			log.Printf("error recording activity - %s", err)
+		}
+		cfg = environments.PseudonymsOf(updated)
+	} else if apiFlag {
+		cfg, err = osctrlAPI.SetPseudonyms(envName, columns, tables)
+		if err != nil {
+			return fmt.Errorf("error setting pseudonyms - %s", err)
+		}
+	}
+	if !silentFlag {
+		if !cfg.Enabled {
+			fmt.Printf("✅ Logs of %s are sent without pseudonyms\n", envName)
+			return nil
+		}
+		fmt.Printf("✅ Logs of %s are pseudonymized, columns: %s - queries: %s\n", envName, strings.Join(cfg.Columns, ","), strings.Join(cfg.Tables, ","))
+	}
+	return nil
+}
+
+func pseudonymLookupEnvironment(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ Environment name is required")
+		os.Exit(1)
+	}
+	pseudonym := c.String("pseudonym")
+	if pseudonym == "" {
+		fmt.Println("❌ Pseudonym is required")
+		os.Exit(1)
+	}
+	var lookup types.ApiPseudonymLookup
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		m, err := envs.LookupPseudonym(env.ID, pseudonym)
+		summary := fmt.Sprintf("pseudonym %s of column %s re-identified", pseudonym, m.Column)
+		if err != nil {
+			summary = fmt.Sprintf("pseudonym %s looked up, not found", pseudonym)
+		}
+		if err := envs.RecordActivity(env.ID, environments.ActivityPseudonyms, appName, summary, "", ""); err != nil {
+			log.Printf("error recording activity - %s", err)
+		}
+		if err != nil {
+			return fmt.Errorf("error getting pseudonym - %s", err)
+		}
+		lookup = types.ApiPseudonymLookup{Pseudonym: m.Pseudonym, Column: m.Column, Value: m.Value}
+	} else if apiFlag {
+		lookup, err = osctrlAPI.LookupPseudonym(envName, pseudonym)
+		if err != nil {
+			return fmt.Errorf("error getting pseudonym - %s", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("%s (%s): %s\n", lookup.Pseudonym, lookup.Column, lookup.Value)
+	}
+	return nil
+}
+
+func listQuickActions(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ Environment name is required")
+		os.Exit(1)
+	}
+	var actions []queries.QuickAction
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		actions, err = queriesmgr.GetQuickActions(env.ID)
+		if err != nil {
+			return fmt.Errorf("error getting quick actions - %s", err)
+		}
+	} else if apiFlag {
+		actions, err = osctrlAPI.GetQuickActions(envName)
+		if err != nil {
+			return fmt.Errorf("error getting quick actions - %s", err)
+		}
+	}
+	header := []string{
+		"Name",
+		"Label",
+		"Type",
+		"Definition",
+		"Version",
+		"Updated By",
+	}
+	data := [][]string{}
+	for _, a := range actions {
+		data = append(data, []string{
+			a.Name,
+			a.Label,
+			a.Type,
+			a.Definition(),
+			strconv.Itoa(a.Version),
+			a.UpdatedBy,
+		})
+	}
+	// Prepare output
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(actions)
+		if err != nil {
+			return fmt.Errorf("error marshaling - %s", err)
+		}
+		fmt.Println(string(jsonRaw))
+	} else if formatFlag == csvFormat {
+		w := csv.NewWriter(os.Stdout)
+		if err := w.WriteAll(append([][]string{header}, data...)); err != nil {
+			return fmt.Errorf("error writting csv - %s", err)
+		}
+	} else if formatFlag == prettyFormat {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader(header)
+		if len(actions) > 0 {
+			fmt.Printf("Existing quick actions in %s (%d):\n", envName, len(actions))
+			table.AppendBulk(data)
+		} else {
+			fmt.Printf("No quick actions in %s\n", envName)
+		}
+		table.Render()
+	}
+	return nil
+}
+
+func setQuickAction(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ Environment name is required")
+		os.Exit(1)
+	}
+	action := c.String("action")
+	if action == "" {
+		fmt.Println("❌ Quick action name is required")
+		os.Exit(1)
+	}
+	vars, err := queries.ParseQuickActionVariables(c.String("variables"))
+	if err != nil {
+		return err
+	}
+	q := types.ApiQuickActionRequest{
+		Name:      action,
+		Label:     c.String("label"),
+		Icon:      c.String("icon"),
+		Type:      c.String("type"),
+		Template:  c.String("template"),
+		Variables: vars,
+		Path:      c.String("path"),
+		Tag:       c.String("tag"),
+	}
+	var saved queries.QuickAction
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		a := queries.QuickAction{Name: q.Name, Label: q.Label, Icon: q.Icon, Type: q.Type, Template: q.Template, Path: q.Path, Tag: q.Tag}
+		if len(vars) > 0 {
+			raw, _ := json.Marshal(vars)
+			a.Variables = string(raw)
+		}
+		saved, err = queriesmgr.SetQuickAction(env.ID, a, appName)
+		if err != nil {
+			return fmt.Errorf("error saving quick action - %s", err)
+		}
+		summary := fmt.Sprintf("quick action %s saved as version %d", saved.Name, saved.Version)
+		if err := envs.RecordActivity(env.ID, environments.ActivityQuickActions, appName, summary, "", saved.Definition()); err != nil {
+			log.Printf("error recording activity - %s", err)
+		}
+	} else if apiFlag {
+		saved, err = osctrlAPI.SetQuickAction(envName, q)
+		if err != nil {
+			return fmt.Errorf("error saving quick action - %s", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ Quick action %s saved as version %d in %s\n", saved.Name, saved.Version, envName)
+	}
+	return nil
+}
+
+func deleteQuickAction(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ Environment name is required")
+		os.Exit(1)
+	}
+	action := c.String("action")
+	if action == "" {
+		fmt.Println("❌ Quick action name is required")
+		os.Exit(1)
+	}
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		if err := queriesmgr.DeleteQuickAction(action, env.ID, appName); err != nil {
+			return fmt.Errorf("error deleting quick action - %s", err)
+		}
+		if err := envs.RecordActivity(env.ID, environments.ActivityQuickActions, appName, "quick action "+action+" deleted", "", ""); err != nil {
+			log.Printf("error recording activity - %s", err)
+		}
+	} else if apiFlag {
+		if _, err := osctrlAPI.DeleteQuickAction(envName, action); err != nil {
+			return fmt.Errorf("error deleting quick action - %s", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ Quick action %s deleted from %s\n", action, envName)
+	}
+	return nil
+}
+
+func runQuickAction(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ Environment name is required")
+		os.Exit(1)
+	}
+	action := c.String("action")
+	if action == "" {
+		fmt.Println("❌ Quick action name is required")
+		os.Exit(1)
+	}
+	uuids := c.StringSlice("uuid")
+	preview := c.Bool("preview")
+	var res types.ApiQuickActionRunResponse
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		a, err := queriesmgr.GetQuickAction(action, env.ID)
+		if err != nil {
+			return fmt.Errorf("error getting quick action - %s", err)
+		}
+		var tpl *queries.QueryTemplate
+		if a.Type == queries.QuickActionQuery {
+			t, err := queriesmgr.GetTemplate(a.Template)
+			if err != nil {
+				return fmt.Errorf("error getting template - %s", err)
+			}
+			tpl = &t
+		}
+		res.Plan, err = queries.PlanQuickAction(a, tpl, uuids)
+		if err != nil {
+			return err
+		}
+		var targets []nodes.OsqueryNode
+		for _, u := range res.Plan.Targets {
+			node, err := nodesmgr.GetByUUIDEnv(u, env.ID)
+			if err != nil {
+				return fmt.Errorf("error getting node %s - %s", u, err)
+			}
+			targets = append(targets, node)
+		}
+		if !preview {
+			if a.Type == queries.QuickActionTag {
+				for _, n := range targets {
+					if err := tagsmgr.TagNode(res.Plan.Tag, n, appName, false); err != nil && err.Error() != "node already tagged" {
+						return fmt.Errorf("error tagging node - %s", err)
+					}
+				}
+			} else {
+				if err := adminUsers.CheckConfirmation(env.UUID, c.Bool("confirm-notices")); err != nil {
+					return err
+				}
+				expiration := queries.NewExpirationRange(int64(env.QueryExpiration), settingsmgr.QueryExpirationMinHours(), settingsmgr.QueryExpirationMaxHours())
+				expiresAt, err := expiration.ExpiresAt("", time.Now())
+				if err != nil {
+					return fmt.Errorf("error expiration - %s", err)
+				}
+				res.Queries, err = queriesmgr.LaunchQuickAction(res.Plan, env.ID, appName, expiresAt)
+				if err != nil {
+					return fmt.Errorf("error running quick action - %s", err)
+				}
+			}
+			if err := envs.RecordActivity(env.ID, environments.ActivityQuickActions, appName, queries.QuickActionRunSummary(res.Plan, res.Queries), "", res.Plan.Summary); err != nil {
+				log.Printf("error recording activity - %s", err)
+			}
+		}
+	} else if apiFlag {
+		res, err = osctrlAPI.RunQuickAction(envName, action, types.ApiQuickActionRunRequest{
+			UUIDs:          uuids,
+			Preview:        preview,
+			ConfirmNotices: c.Bool("confirm-notices"),
+		})
+		if err != nil {
+			return fmt.Errorf("error running quick action - %s", err)
+		}
+	}
+	if !silentFlag {
+		if preview {
+			fmt.Println(res.Plan.Summary)
+			return nil
+		}
+		fmt.Printf("✅ %s\n", res.Plan.Summary)
+		for _, q := range res.Queries {
+			fmt.Printf("   %s\n", q)
+		}
+	}
+	return nil
+}
+
+func showPackSource(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ Environment name is required")
+		os.Exit(1)
+	}
+	var status environments.PackSyncStatus
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		status, err = envs.PackSyncStatus(env.ID)
+		if err != nil {
+			return fmt.Errorf("error getting pack source - %s", err)
+		}
+	} else if apiFlag {
+		status, err = osctrlAPI.GetPackSource(envName)
+		if err != nil {
+			return fmt.Errorf("error getting pack source - %s", err)
+		}
+	}
+	header := []string{
+		"Pack",
+		"File",
+		"Commit",
+		"Resolution",
+		"Conflict",
+	}
+	data := [][]string{}
+	for _, p := range status.Packs {
+		data = append(data, []string{
+			p.Name,
+			p.Path,
+			p.Commit,
+			p.Resolution,
+			stringifyBool(p.Conflict),
+		})
+	}
+	if formatFlag == prettyFormat {
+		s := status.Source
+		fmt.Printf("Pack source of %s: %s@%s (%s)\n", envName, s.URL, s.Branch, s.PathGlob)
+		fmt.Printf("Enabled: %s - every %d minutes - auth %s\n", stringifyBool(s.Enabled), s.Interval, s.AuthKind)
+		if !s.LastAttempt.IsZero() {
+			fmt.Printf("Last sync %s: %s\n", s.LastAttempt.Format(time.RFC3339), s.LastSummary)
+		}
+	}
+	return printResponsesData(status, header, data, fmt.Sprintf("Synced packs in %s (%d, %d conflicts):", envName, len(status.Packs), status.Conflicts), fmt.Sprintf("No synced packs in %s", envName))
+}
+
+func setPackSource(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ Environment name is required")
+		os.Exit(1)
+	}
+	repo := c.String("url")
+	if repo == "" {
+		fmt.Println("❌ Repository URL is required")
+		os.Exit(1)
+	}
+	s := types.ApiPackSourceRequest{
+		URL:        repo,
+		Branch:     c.String("branch"),
+		PathGlob:   c.String("path-glob"),
+		AuthKind:   c.String("auth"),
+		Credential: c.String("credential"),
+		Interval:   c.Int("interval"),
+		Enabled:    c.Bool("enabled"),
+	}
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		before, _ := envs.GetPackSource(env.ID)
+		source, err := envs.SetPackSource(environments.PackSource{
+			EnvironmentID: env.ID,
+			URL:           s.URL,
+			Branch:        s.Branch,
+			PathGlob:      s.PathGlob,
+			AuthKind:      s.AuthKind,
+			Credential:    s.Credential,
+			Interval:      s.Interval,
+			Enabled:       s.Enabled,
+			CreatedBy:     appName,
+		})
+		if err != nil {
+			return fmt.Errorf("error setting pack source - %s", err)
+		}
+		if err := envs.RecordActivity(env.ID, environments.ActivityPackSync, appName, "pack source set to "+source.URL+"@"+source.Branch, before.String(), source.String()); err != nil {
+			log.Printf("error recording activity - %s", err)
+		}
+	} else if apiFlag {
+		if _, err := osctrlAPI.SetPackSource(envName, s); err != nil {
+			return fmt.Errorf("error setting pack source - %s", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ Pack source of %s set to %s\n", envName, repo)
+	}
+	return nil
+}
+
+func syncPacks(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ Environment name is required")
+		os.Exit(1)
+	}
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		if err := envs.RequestPackSync(env.ID, appName); err != nil {
+			return fmt.Errorf("error requesting pack sync - %s", err)
+		}
+	} else if apiFlag {
+		if _, err := osctrlAPI.PackSourceAction(envName, "sync", nil); err != nil {
+			return fmt.Errorf("error requesting pack sync - %s", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ Pack sync of %s requested\n", envName)
+	}
+	return nil
+}
+
+func resolvePack(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ Environment name is required")
+		os.Exit(1)
+	}
+	pack := c.String("pack")
+	if pack == "" {
+		fmt.Println("❌ Pack name is required")
+		os.Exit(1)
+	}
+	resolution := c.String("resolution")
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		if err := envs.ResolvePackConflict(env.ID, pack, resolution, appName); err != nil {
+			return fmt.Errorf("error resolving pack conflict - %s", err)
+		}
+		if err := envs.RecordActivity(env.ID, environments.ActivityPackSync, appName, "pack "+pack+" resolution set to "+resolution, "", ""); err != nil {
+			log.Printf("error recording activity - %s", err)
+		}
+	} else if apiFlag {
+		r := types.ApiPackResolveRequest{Pack: pack, Resolution: resolution}
+		if _, err := osctrlAPI.PackSourceAction(envName, "resolve", r); err != nil {
+			return fmt.Errorf("error resolving pack conflict - %s", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ Pack %s in %s resolved\n", pack, envName)
+	}
+	return nil
+}
+
+func deletePackSource(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ Environment name is required")
+		os.Exit(1)
+	}
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		before, _ := envs.GetPackSource(env.ID)
+		if err := envs.DeletePackSource(env.ID); err != nil {
+			return fmt.Errorf("error deleting pack source - %s", err)
+		}
+		if err := envs.RecordActivity(env.ID, environments.ActivityPackSync, appName, "pack source deleted", before.String(), ""); err != nil {
+			log.Printf("error recording activity - %s", err)
+		}
+	} else if apiFlag {
+		if _, err := osctrlAPI.PackSourceAction(envName, "delete", nil); err != nil {
+			return fmt.Errorf("error deleting pack source - %s", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ Pack source of %s deleted\n", envName)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/tags"
+	"github.com/olekukonko/tablewriter"
+	"github.com/urfave/cli/v2"
+)
+
+func createEnvironment(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ Environment name is required")
+		os.Exit(1)
+	}
+	// Get template name
+	templateName := c.String("template")
+	if templateName == "" {
+		fmt.Println("❌ Template name is required")
+		os.Exit(1)
+	}
+	var env environments.TLSEnvironment
+	if dbFlag {
+		if envs.Exists(envName) {
+			fmt.Printf("Environment %s already exists!\n", envName)
+			os.Exit(1)
+		}
+		env, err = envs.CreateFromTemplate(templateName, envName, c.String("hostname"), appName)
+		if err != nil {
+			return fmt.Errorf("error creating environment - %s", err)
+		}
+		// Create a tag for this new environment
+		if err := tagsmgr.NewTag(env.Name, "Tag for environment "+env.Name, tags.RandomColor(), env.Icon, appName); err != nil {
+			return err
+		}
+	} else if apiFlag {
+		env, err = osctrlAPI.CreateEnvironment(envName, c.String("hostname"), templateName)
+		if err != nil {
+			return fmt.Errorf("error creating environment - %s", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ Environment %s was created from template %s version %d\n", env.Name, templateName, env.TemplateVersion)
+	}
+	return nil
+}
+
+func listEnvTemplates(c *cli.Context) error {
+	var envTemplates []environments.EnvTemplate
+	if dbFlag {
+		envTemplates, err = envs.Templates()
+		if err != nil {
+			return fmt.Errorf("error getting templates - %s", err)
+		}
+	} else if apiFlag {
+		envTemplates, err = osctrlAPI.GetEnvTemplates()
+		if err != nil {
+			return fmt.Errorf("error getting templates - %s", err)
+		}
+	}
+	header := []string{
+		"Name",
+		"Description",
+		"Version",
+		"Author",
+		"Updated",
+	}
+	data := [][]string{}
+	for _, t := range envTemplates {
+		data = append(data, []string{
+			t.Name,
+			t.Description,
+			strconv.Itoa(t.Version),
+			t.Author,
+			t.UpdatedAt.Format("2006-01-02 15:04:05"),
+		})
+	}
+	// Prepare output
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(envTemplates)
+		if err != nil {
+			return fmt.Errorf("error marshaling - %s", err)
+		}
+		fmt.Println(string(jsonRaw))
+	} else if formatFlag == csvFormat {
+		w := csv.NewWriter(os.Stdout)
+		if err := w.WriteAll(append([][]string{header}, data...)); err != nil {
+			return fmt.Errorf("error writting csv - %s", err)
+		}
+	} else if formatFlag == prettyFormat {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader(header)
+		if len(envTemplates) > 0 {
+			fmt.Printf("Existing environment templates (%d):\n", len(envTemplates))
+			table.AppendBulk(data)
+		} else {
+			fmt.Printf("No environment templates\n")
+		}
+		table.Render()
+	}
+	return nil
+}
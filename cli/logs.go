@@ -0,0 +1,392 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmpsec/osctrl/backend"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/logging"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/olekukonko/tablewriter"
+	"github.com/urfave/cli/v2"
+)
+
+// Helper to prepare the dead letters selected with the flags of replay and purge
+func deadLettersRequest(c *cli.Context) (types.ApiDeadLetterRequest, error) {
+	req := types.ApiDeadLetterRequest{
+		Backend:     c.String("backend"),
+		Reason:      c.String("reason"),
+		Environment: c.String("env"),
+		OlderThan:   c.String("older-than"),
+		Target:      c.String("target"),
+		Limit:       c.Int("limit"),
+		All:         c.Bool("all"),
+	}
+	for _, id := range c.Int64Slice("id") {
+		req.IDs = append(req.IDs, uint(id))
+	}
+	filter, err := deadLettersFilter(req)
+	if err != nil {
+		return req, err
+	}
+	if filter.Empty() && !req.All {
+		return req, fmt.Errorf("no dead letters selected, use --all to select every dead letter")
+	}
+	return req, nil
+}
+
+// Helper to convert the dead letters selected in a request to a filter
+func deadLettersFilter(req types.ApiDeadLetterRequest) (logging.DeadLetterFilter, error) {
+	filter := logging.DeadLetterFilter{
+		IDs:         req.IDs,
+		Backend:     req.Backend,
+		Reason:      req.Reason,
+		Environment: req.Environment,
+		Limit:       req.Limit,
+	}
+	if req.OlderThan != "" {
+		before, err := environments.ParseSince(req.OlderThan, time.Now())
+		if err != nil {
+			return filter, fmt.Errorf("invalid older-than %s - %s", req.OlderThan, err)
+		}
+		filter.Before = before
+	}
+	return filter, nil
+}
+
+func listDeadLetters(c *cli.Context) error {
+	var report logging.DeadLetterReport
+	if dbFlag {
+		report, err = deadletters.Report(logging.DeadLetterFilter{
+			Backend:     c.String("backend"),
+			Reason:      c.String("reason"),
+			Environment: c.String("env"),
+			Limit:       c.Int("limit"),
+		})
+		if err != nil {
+			return fmt.Errorf("error getting dead letters - %s", err)
+		}
+	} else if apiFlag {
+		filter := url.Values{}
+		for _, f := range []string{"backend", "reason", "env"} {
+			if v := c.String(f); v != "" {
+				filter.Set(f, v)
+			}
+		}
+		filter.Set("limit", strconv.Itoa(c.Int("limit")))
+		report, err = osctrlAPI.GetDeadLetters(filter)
+		if err != nil {
+			return fmt.Errorf("error getting dead letters - %s", err)
+		}
+	}
+	header := []string{
+		"ID",
+		"Backend",
+		"Reason",
+		"State",
+		"Type",
+		"Environment",
+		"Node",
+		"Size",
+		"Attempts",
+		"Failed",
+	}
+	data := [][]string{}
+	for _, d := range report.Entries {
+		data = append(data, []string{
+			strconv.FormatUint(uint64(d.ID), 10),
+			d.Backend,
+			d.Reason,
+			d.State,
+			d.LogType,
+			d.Environment,
+			d.UUID,
+			strconv.Itoa(d.Size),
+			strconv.Itoa(d.Attempts),
+			d.FailedAt.Format("2006-01-02 15:04:05"),
+		})
+	}
+	// Prepare output
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(report)
+		if err != nil {
+			return fmt.Errorf("error marshaling - %s", err)
+		}
+		fmt.Println(string(jsonRaw))
+	} else if formatFlag == csvFormat {
+		w := csv.NewWriter(os.Stdout)
+		if err := w.WriteAll(append([][]string{header}, data...)); err != nil {
+			return fmt.Errorf("error writting csv - %s", err)
+		}
+	} else if formatFlag == prettyFormat {
+		counts := tablewriter.NewWriter(os.Stdout)
+		counts.SetHeader([]string{"Backend", "Reason", "State", "Count", "Size"})
+		for _, r := range report.Counts {
+			counts.Append([]string{r.Backend, r.Reason, r.State, strconv.FormatInt(r.Count, 10), strconv.FormatInt(r.Size, 10)})
+		}
+		if len(report.Counts) == 0 {
+			fmt.Println("No dead letters")
+			return nil
+		}
+		fmt.Println("Dead letters by reason:")
+		counts.Render()
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader(header)
+		if len(data) > 0 {
+			fmt.Printf("Latest dead letters (%d):\n", len(data))
+			table.AppendBulk(data)
+		} else {
+			fmt.Println("No dead letters match the filters")
+		}
+		table.Render()
+	}
+	return nil
+}
+
+func replayDeadLetters(c *cli.Context) error {
+	req, err := deadLettersRequest(c)
+	if err != nil {
+		return err
+	}
+	var msg string
+	if dbFlag {
+		filter, err := deadLettersFilter(req)
+		if err != nil {
+			return err
+		}
+		replayed, err := deadletters.Replay(filter, req.Target)
+		if err != nil {
+			return fmt.Errorf("error replaying dead letters - %s", err)
+		}
+		msg = fmt.Sprintf("%d dead letters queued for replay", replayed)
+	} else if apiFlag {
+		r, err := osctrlAPI.ReplayDeadLetters(req)
+		if err != nil {
+			return fmt.Errorf("error replaying dead letters - %s", err)
+		}
+		msg = r.Message
+	}
+	if !silentFlag {
+		fmt.Printf("✅ %s\n", msg)
+	}
+	return nil
+}
+
+func purgeDeadLetters(c *cli.Context) error {
+	req, err := deadLettersRequest(c)
+	if err != nil {
+		return err
+	}
+	var msg string
+	if dbFlag {
+		filter, err := deadLettersFilter(req)
+		if err != nil {
+			return err
+		}
+		purged, err := deadletters.Purge(filter)
+		if err != nil {
+			return fmt.Errorf("error purging dead letters - %s", err)
+		}
+		msg = fmt.Sprintf("%d dead letters purged", purged)
+	} else if apiFlag {
+		r, err := osctrlAPI.PurgeDeadLetters(req)
+		if err != nil {
+			return fmt.Errorf("error purging dead letters - %s", err)
+		}
+		msg = r.Message
+	}
+	if !silentFlag {
+		fmt.Printf("✅ %s\n", msg)
+	}
+	return nil
+}
+
+// Helper to get the flags to select dead letters for replay and purge
+func deadLetterFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.Int64SliceFlag{
+			Name:  "id",
+			Usage: "ID of the dead letter, can be used multiple times",
+		},
+		&cli.StringFlag{
+			Name:    "backend",
+			Aliases: []string{"b"},
+			Usage:   "Backend that failed the delivery, like splunk or kinesis",
+		},
+		&cli.StringFlag{
+			Name:    "reason",
+			Aliases: []string{"r"},
+			Usage:   "Reason of the failed delivery, like rejected or payload-too-large",
+		},
+		&cli.StringFlag{
+			Name:    "env",
+			Aliases: []string{"e"},
+			Usage:   "Environment of the logs",
+		},
+		&cli.StringFlag{
+			Name:    "older-than",
+			Aliases: []string{"o"},
+			Usage:   "Only dead letters older than a duration like 7d, or a date",
+		},
+		&cli.IntFlag{
+			Name:    "limit",
+			Aliases: []string{"l"},
+			Usage:   "Maximum dead letters to select, oldest first",
+		},
+		&cli.BoolFlag{
+			Name:    "all",
+			Aliases: []string{"A"},
+			Usage:   "Select all dead letters when no other filter is used",
+		},
+	}
+}
+
+// Helper to map host identifiers of logs to the nodes of one environment, by UUID, hostname or localname
+func envNodeMap(env string) (func(identifier string) (string, bool), error) {
+	nodes, err := nodesmgr.GetByEnv(env, "all", 0)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]string)
+	for _, n := range nodes {
+		for _, id := range []string{n.Localname, n.Hostname, n.UUID} {
+			if id != "" {
+				byID[strings.ToLower(id)] = strings.ToUpper(n.UUID)
+			}
+		}
+	}
+	return func(identifier string) (string, bool) {
+		uuid, ok := byID[strings.ToLower(identifier)]
+		return uuid, ok
+	}, nil
+}
+
+func importS3Logs(c *cli.Context) error {
+	if !dbFlag {
+		return fmt.Errorf("importing logs from S3 needs access to the DB")
+	}
+	envName := c.String("env")
+	if envName == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	env, err := envs.Get(envName)
+	if err != nil {
+		return fmt.Errorf("error getting environment %s - %s", envName, err)
+	}
+	var s3Config types.S3Configuration
+	if f := c.String("s3-config"); f != "" {
+		s3Config, err = logging.LoadS3(f)
+		if err != nil {
+			return fmt.Errorf("error loading S3 configuration - %s", err)
+		}
+	}
+	if b := c.String("bucket"); b != "" {
+		s3Config.Bucket = b
+	}
+	if r := c.String("region"); r != "" {
+		s3Config.Region = r
+	}
+	if s3Config.Bucket == "" {
+		fmt.Println("❌ bucket is required")
+		os.Exit(1)
+	}
+	source, err := logging.CreateS3Objects(s3Config)
+	if err != nil {
+		return fmt.Errorf("error creating S3 client - %s", err)
+	}
+	// Logs go to the DB of the DB logger when it has its own configuration
+	loggerDB := db.Conn
+	if f := c.String("logger-db"); f != "" {
+		ldb, err := backend.CreateDBManagerFile(f)
+		if err != nil {
+			return fmt.Errorf("error creating logger DB - %s", err)
+		}
+		loggerDB = ldb.Conn
+	}
+	store, err := logging.CreateDBImportStore(loggerDB)
+	if err != nil {
+		return fmt.Errorf("error preparing logger DB - %s", err)
+	}
+	nodeMap, err := envNodeMap(env.Name)
+	if err != nil {
+		return fmt.Errorf("error getting nodes - %s", err)
+	}
+	prefix := c.String("prefix")
+	if !c.IsSet("prefix") {
+		prefix = env.Name + "/"
+	}
+	importer := &logging.S3Import{
+		Source:      source,
+		Store:       store,
+		Bucket:      s3Config.Bucket,
+		Prefix:      prefix,
+		Environment: env.Name,
+		Retention:   time.Duration(c.Int("retention")) * 24 * time.Hour,
+		Batch:       c.Int("batch"),
+		Node:        nodeMap,
+		Checkpoint:  c.String("checkpoint"),
+	}
+	if from := c.String("from"); from != "" {
+		if importer.From, err = time.Parse("2006-01-02", from); err != nil {
+			return fmt.Errorf("invalid from %s - %s", from, err)
+		}
+	}
+	if to := c.String("to"); to != "" {
+		if importer.To, err = time.Parse("2006-01-02", to); err != nil {
+			return fmt.Errorf("invalid to %s - %s", to, err)
+		}
+		// The last day is included
+		importer.To = importer.To.AddDate(0, 0, 1)
+	}
+	if !silentFlag && formatFlag != jsonFormat {
+		importer.Progress = func(key string, stats logging.S3ImportStats) {
+			fmt.Printf("%s: %d objects, %d imported, %d entries\n", key, stats.Objects, stats.Imported, stats.Entries)
+		}
+	}
+	stats, err := importer.Run(c.Context)
+	if err != nil {
+		return fmt.Errorf("error importing logs, run it again to resume - %s", err)
+	}
+	if silentFlag {
+		return nil
+	}
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(stats)
+		if err != nil {
+			return fmt.Errorf("error marshaling - %s", err)
+		}
+		fmt.Println(string(jsonRaw))
+		return nil
+	}
+	fmt.Printf("✅ %d objects imported and %d skipped, %d entries imported\n", stats.Imported, stats.Skipped, stats.Entries)
+	fmt.Printf("%d entries older than the retention, %d of unknown hosts and %d malformed skipped\n", stats.Old, stats.Unmatched, stats.Malformed)
+	if len(stats.Hosts) > 0 {
+		data := [][]string{}
+		for h, n := range stats.Hosts {
+			data = append(data, []string{h, strconv.Itoa(n)})
+		}
+		sort.Slice(data, func(i, j int) bool { return data[i][0] < data[j][0] })
+		if formatFlag == csvFormat {
+			w := csv.NewWriter(os.Stdout)
+			if err := w.WriteAll(append([][]string{{"Host", "Entries"}}, data...)); err != nil {
+				return fmt.Errorf("error writting csv - %s", err)
+			}
+			return nil
+		}
+		fmt.Println("Unknown hosts:")
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Host", "Entries"})
+		table.AppendBulk(data)
+		table.Render()
+	}
+	return nil
+}
@@ -4,17 +4,26 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/jmpsec/osctrl/backend"
+	"github.com/jmpsec/osctrl/backup"
+	"github.com/jmpsec/osctrl/cache"
 	"github.com/jmpsec/osctrl/carves"
 	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/locales"
+	"github.com/jmpsec/osctrl/logging"
 	"github.com/jmpsec/osctrl/nodes"
 	"github.com/jmpsec/osctrl/queries"
+	"github.com/jmpsec/osctrl/reports"
+	"github.com/jmpsec/osctrl/responses"
 	"github.com/jmpsec/osctrl/settings"
 	"github.com/jmpsec/osctrl/tags"
 	"github.com/jmpsec/osctrl/types"
 	"github.com/jmpsec/osctrl/users"
 	"github.com/jmpsec/osctrl/version"
+	"github.com/jmpsec/osctrl/webhooks"
 	"golang.org/x/term"
 
 	"github.com/urfave/cli/v2"
@@ -44,22 +53,26 @@ const (
 
 // Global variables
 var (
-	err         error
-	app         *cli.App
-	dbConfig    backend.JSONConfigurationDB
-	apiConfig   JSONConfigurationAPI
-	flags       []cli.Flag
-	commands    []*cli.Command
-	settingsmgr *settings.Settings
-	nodesmgr    *nodes.NodeManager
-	queriesmgr  *queries.Queries
-	filecarves  *carves.Carves
-	adminUsers  *users.UserManager
-	tagsmgr     *tags.TagManager
-	envs        *environments.Environment
-	db          *backend.DBManager
-	osctrlAPI   *OsctrlAPI
-	formats     map[string]bool
+	err          error
+	app          *cli.App
+	dbConfig     backend.JSONConfigurationDB
+	apiConfig    JSONConfigurationAPI
+	flags        []cli.Flag
+	commands     []*cli.Command
+	settingsmgr  *settings.Settings
+	nodesmgr     *nodes.NodeManager
+	queriesmgr   *queries.Queries
+	filecarves   *carves.Carves
+	adminUsers   *users.UserManager
+	tagsmgr      *tags.TagManager
+	reportsmgr   *reports.ReportManager
+	deadletters  *logging.DeadLetters
+	responsesmgr *responses.ResponseManager
+	webhooksmgr  *webhooks.WebhookManager
+	envs         *environments.Environment
+	db           *backend.DBManager
+	osctrlAPI    *OsctrlAPI
+	formats      map[string]bool
 )
 
 // Variables for flags
@@ -180,6 +193,13 @@ func init() {
 			EnvVars:     []string{"DB_CONN_MAX_LIFETIME"},
 			Destination: &dbConfig.ConnMaxLifetime,
 		},
+		&cli.IntFlag{
+			Name:        "db-conn-max-idle-time",
+			Value:       0,
+			Usage:       "Maximum amount of time in seconds a connection may be idle, 0 to keep idle connections",
+			EnvVars:     []string{"DB_CONN_MAX_IDLE_TIME"},
+			Destination: &dbConfig.ConnMaxIdleTime,
+		},
 		&cli.BoolFlag{
 			Name:        "insecure",
 			Aliases:     []string{"i"},
@@ -325,13 +345,13 @@ func init() {
 							Name:    "query",
 							Aliases: []string{"q"},
 							Hidden:  false,
-							Usage:   "Grant query permissions",
+							Usage:   "Grant query permissions, --query=false revokes them",
 						},
 						&cli.BoolFlag{
 							Name:    "carve",
 							Aliases: []string{"c"},
 							Hidden:  false,
-							Usage:   "Grant carve permissions",
+							Usage:   "Grant carve permissions, --carve=false revokes them",
 						},
 					},
 					Action: cliWrapper(changePermissions),
@@ -409,6 +429,86 @@ func init() {
 					},
 					Action: cliWrapper(allPermissions),
 				},
+				{
+					Name:    "permissions",
+					Aliases: []string{"P", "bulk"},
+					Usage:   "Manage permissions for an existing user in multiple environments at once",
+					Subcommands: []*cli.Command{
+						{
+							Name:  "set",
+							Usage: "Replace all the permissions of the user",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "username",
+									Aliases: []string{"u"},
+									Usage:   "User to perform the action",
+								},
+								&cli.StringFlag{
+									Name:    "envs",
+									Aliases: []string{"E"},
+									Usage:   "Comma separated list of environments, by name or UUID",
+								},
+								&cli.StringFlag{
+									Name:    "level",
+									Aliases: []string{"l"},
+									Value:   users.LevelUser,
+									Usage:   "Access level in the environments: user, query, carve or admin",
+								},
+								&cli.StringFlag{
+									Name:    "from-user",
+									Aliases: []string{"f"},
+									Usage:   "Copy the permissions of this user",
+								},
+							},
+							Action: cliWrapper(setPermissions),
+						},
+						{
+							Name:  "add",
+							Usage: "Add permissions for the user, keeping the existing ones",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "username",
+									Aliases: []string{"u"},
+									Usage:   "User to perform the action",
+								},
+								&cli.StringFlag{
+									Name:    "envs",
+									Aliases: []string{"E"},
+									Usage:   "Comma separated list of environments, by name or UUID",
+								},
+								&cli.StringFlag{
+									Name:    "level",
+									Aliases: []string{"l"},
+									Value:   users.LevelUser,
+									Usage:   "Access level in the environments: user, query, carve or admin",
+								},
+								&cli.StringFlag{
+									Name:    "from-user",
+									Aliases: []string{"f"},
+									Usage:   "Copy the permissions of this user",
+								},
+							},
+							Action: cliWrapper(addPermissions),
+						},
+						{
+							Name:  "remove",
+							Usage: "Remove all the permissions of the user in the environments",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "username",
+									Aliases: []string{"u"},
+									Usage:   "User to perform the action",
+								},
+								&cli.StringFlag{
+									Name:    "envs",
+									Aliases: []string{"E"},
+									Usage:   "Comma separated list of environments, by name or UUID",
+								},
+							},
+							Action: cliWrapper(removePermissions),
+						},
+					},
+				},
 				{
 					Name:    "delete",
 					Aliases: []string{"d"},
@@ -422,6 +522,22 @@ func init() {
 					},
 					Action: cliWrapper(deleteUser),
 				},
+				{
+					Name:  "unlock",
+					Usage: "Unlock a username or an IP address locked out after failed logins",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "username",
+							Aliases: []string{"u"},
+							Usage:   "User to be unlocked",
+						},
+						&cli.StringFlag{
+							Name:  "ip",
+							Usage: "IP address to be unlocked",
+						},
+					},
+					Action: cliWrapper(unlockUser),
+				},
 				{
 					Name:    "show",
 					Aliases: []string{"s"},
@@ -475,9 +591,40 @@ func init() {
 							Value:   "",
 							Usage:   "Certificate file to be read",
 						},
+						&cli.StringFlag{
+							Name:  "category",
+							Usage: "Category of the environment: production, staging, development, lab or custom",
+						},
 					},
 					Action: cliWrapper(addEnvironment),
 				},
+				{
+					Name:  "create",
+					Usage: "Create a new TLS environment from a template, with new secrets",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Environment name to be created",
+						},
+						&cli.StringFlag{
+							Name:    "template",
+							Aliases: []string{"t"},
+							Usage:   "Template to create the environment from",
+						},
+						&cli.StringFlag{
+							Name:    "hostname",
+							Aliases: []string{"host"},
+							Usage:   "Environment host, to override the hostname of the template",
+						},
+					},
+					Action: cliWrapper(createEnvironment),
+				},
+				{
+					Name:   "templates",
+					Usage:  "List all environment templates",
+					Action: cliWrapper(listEnvTemplates),
+				},
 				{
 					Name:    "update",
 					Aliases: []string{"u"},
@@ -521,9 +668,113 @@ func init() {
 							Value:   0,
 							Usage:   "Query interval in seconds",
 						},
+						&cli.StringFlag{
+							Name:  "carve-scan",
+							Usage: "Policy to download scanned carves, off or enforce",
+						},
+						&cli.StringFlag{
+							Name:  "identity-policy",
+							Usage: "Policy to bind nodes to their host on first use, off, monitor or enforce to stop queries and carves on mismatch",
+						},
+						&cli.IntFlag{
+							Name:  "storm-threshold",
+							Usage: "Requests per minute that trigger check-in storm protection, 0 to disable",
+						},
+						&cli.IntFlag{
+							Name:  "enroll-loop-limit",
+							Usage: "Enrolls per hour to flag a host as enrolling in a loop, 0 for the default",
+						},
+						&cli.IntFlag{
+							Name:  "enroll-loop-quiet",
+							Usage: "Minutes without enrolls to clear the flag of a looping host, 0 for the default",
+						},
+						&cli.BoolFlag{
+							Name:  "enroll-backoff",
+							Usage: "Looping hosts get a backoff response instead of enrolling again",
+						},
+						&cli.BoolFlag{
+							Name:  "naming-lowercase",
+							Usage: "Lowercase the display names of nodes",
+						},
+						&cli.StringFlag{
+							Name:  "naming-domains",
+							Usage: "Comma separated domains stripped from the display names of nodes",
+						},
+						&cli.BoolFlag{
+							Name:  "naming-prefer-fqdn",
+							Usage: "Display names of nodes use the FQDN of the host details instead of the localname",
+						},
+						&cli.StringFlag{
+							Name:  "locale",
+							Usage: "Locale for enroll scripts and reports, " + strings.Join(locales.Locales(), " or "),
+						},
+						&cli.StringFlag{
+							Name:  "platforms",
+							Usage: "Comma separated platforms allowed to enroll, empty for any platform",
+						},
+						&cli.StringFlag{
+							Name:  "osquery-pin",
+							Usage: "Osquery version installed by enroll scripts, empty to remove the pin",
+						},
+						&cli.StringFlag{
+							Name:  "osquery-pins",
+							Usage: "Comma separated osquery versions by platform, like windows=5.5.1",
+						},
+						&cli.StringFlag{
+							Name:  "enroll-path",
+							Usage: "Path of the enroll endpoint for osquery, nodes need new flags to use it",
+						},
+						&cli.StringFlag{
+							Name:  "log-path",
+							Usage: "Path of the log endpoint for osquery, nodes need new flags to use it",
+						},
+						&cli.StringFlag{
+							Name:  "config-path",
+							Usage: "Path of the config endpoint for osquery, nodes need new flags to use it",
+						},
+						&cli.StringFlag{
+							Name:  "read-path",
+							Usage: "Path of the on-demand query read endpoint for osquery, nodes need new flags to use it",
+						},
+						&cli.StringFlag{
+							Name:  "write-path",
+							Usage: "Path of the on-demand query write endpoint for osquery, nodes need new flags to use it",
+						},
+						&cli.StringFlag{
+							Name:  "carver-init-path",
+							Usage: "Path of the carver init endpoint for osquery, nodes need new flags to use it",
+						},
+						&cli.StringFlag{
+							Name:  "carver-block-path",
+							Usage: "Path of the carver block endpoint for osquery, nodes need new flags to use it",
+						},
+						&cli.BoolFlag{
+							Name:  "random-paths",
+							Usage: "Generate random paths for all the endpoints for osquery, nodes need new flags to use them",
+						},
+						&cli.StringFlag{
+							Name:  "category",
+							Usage: "Category of the environment: production, staging, development, lab or custom, empty to remove it",
+						},
 					},
 					Action: cliWrapper(updateEnvironment),
 				},
+				{
+					Name:  "normalize-names",
+					Usage: "Normalize the display names of existing nodes with the naming policy of an environment",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Environment name to be used",
+						},
+						&cli.BoolFlag{
+							Name:  "dry-run",
+							Usage: "Show how many names would change and the collisions, without changing them",
+						},
+					},
+					Action: cliWrapper(normalizeNodeNames),
+				},
 				{
 					Name:  "add-scheduled-query",
 					Usage: "Add a new query to the osquery schedule for an environment",
@@ -839,7 +1090,42 @@ func init() {
 					Name:    "list",
 					Aliases: []string{"l"},
 					Usage:   "List all existing TLS environments",
-					Action:  cliWrapper(listEnvironment),
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:  "category",
+							Usage: "List only the environments of a category",
+						},
+					},
+					Action: cliWrapper(listEnvironment),
+				},
+				{
+					Name:   "categories",
+					Usage:  "Show the policies of the categories of environments",
+					Action: cliWrapper(listCategoryPolicies),
+				},
+				{
+					Name:  "category-policy",
+					Usage: "Change the policy of a category of environments",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:     "category",
+							Required: true,
+							Usage:    "Category to change: production, staging, development, lab or custom",
+						},
+						&cli.IntFlag{
+							Name:  "confirm-targets",
+							Usage: "Launches of queries and carves over this many targets need the name of the environment typed, 0 to disable",
+						},
+						&cli.BoolFlag{
+							Name:  "require-note",
+							Usage: "Changes of configuration need a note",
+						},
+						&cli.IntFlag{
+							Name:  "query-expiration",
+							Usage: "Default hours until queries expire for environments without their own, 0 for the default",
+						},
+					},
+					Action: cliWrapper(setCategoryPolicy),
 				},
 				{
 					Name:    "quick-add",
@@ -902,537 +1188,3237 @@ func init() {
 					},
 					Action: cliWrapper(secretEnvironment),
 				},
-			},
-		},
-		{
-			Name:  "settings",
-			Usage: "Commands for settings",
-			Subcommands: []*cli.Command{
 				{
-					Name:    "add",
-					Aliases: []string{"a"},
-					Usage:   "Add a new settings value",
+					Name:  "certificate",
+					Usage: "Stage a new version of the certificate served to nodes in an environment",
 					Flags: []cli.Flag{
 						&cli.StringFlag{
 							Name:    "name",
 							Aliases: []string{"n"},
-							Usage:   "Value name to be added",
-						},
-						&cli.StringFlag{
-							Name:    "service",
-							Aliases: []string{"s"},
-							Usage:   "Value service to be added",
-						},
-						&cli.StringFlag{
-							Name:    "type, t",
-							Aliases: []string{"t"},
-							Usage:   "Value type to be added",
+							Usage:   "Environment name to be used",
 						},
 						&cli.StringFlag{
-							Name:  "string",
-							Value: "",
-							Usage: "Value string",
-						},
-						&cli.Int64Flag{
-							Name:  "integer",
-							Value: 0,
-							Usage: "Value integer",
+							Name:    "certificate",
+							Aliases: []string{"crt"},
+							Usage:   "Certificate file to be read",
 						},
-						&cli.BoolFlag{
-							Name:   "boolean",
-							Hidden: false,
-							Usage:  "Value boolean",
+						&cli.TimestampFlag{
+							Name:   "activate",
+							Layout: time.RFC3339,
+							Usage:  "Time to start serving the certificate (RFC3339), now if empty",
 						},
+					},
+					Action: cliWrapper(certificateEnvironment),
+				},
+				{
+					Name:  "certificate-status",
+					Usage: "Show how many nodes in an environment retrieved each certificate version",
+					Flags: []cli.Flag{
 						&cli.StringFlag{
-							Name:    "info",
-							Aliases: []string{"i"},
-							Value:   "",
-							Usage:   "Setting info",
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Environment name to be used",
 						},
 					},
-					Action: cliWrapper(addSetting),
+					Action: cliWrapper(certificateStatusEnvironment),
 				},
 				{
-					Name:    "update",
-					Aliases: []string{"u"},
-					Usage:   "Update a configuration value",
+					Name:  "activity",
+					Usage: "Show what changed in an environment, newest first",
 					Flags: []cli.Flag{
 						&cli.StringFlag{
 							Name:    "name",
 							Aliases: []string{"n"},
-							Usage:   "Value name to be updated",
+							Usage:   "Environment name to be used",
 						},
 						&cli.StringFlag{
-							Name:    "service",
+							Name:    "since",
 							Aliases: []string{"s"},
-							Usage:   "Value service to be updated",
+							Value:   "7d",
+							Usage:   "Show changes since a duration ago like 7d or 12h, or since a date",
 						},
-						&cli.StringFlag{
-							Name:    "type",
-							Aliases: []string{"t"},
-							Usage:   "Value type to be updated",
+						&cli.StringSliceFlag{
+							Name:    "kind",
+							Aliases: []string{"k"},
+							Usage:   "Kind of changes to show, like configuration, packs, secret or permissions",
 						},
 						&cli.StringFlag{
-							Name:  "string",
-							Value: "",
-							Usage: "Value string",
-						},
-						&cli.Int64Flag{
-							Name:  "integer",
-							Value: 0,
-							Usage: "Value integer",
-						},
-						&cli.BoolFlag{
-							Name:   "true",
-							Hidden: false,
-							Usage:  "Value boolean true",
+							Name:    "actor",
+							Aliases: []string{"a"},
+							Usage:   "Show only changes made by this user",
 						},
-						&cli.BoolFlag{
-							Name:   "false",
-							Hidden: false,
-							Usage:  "Value boolean false",
+						&cli.IntFlag{
+							Name:    "page",
+							Aliases: []string{"p"},
+							Value:   1,
+							Usage:   "Page of changes to show",
 						},
-						&cli.StringFlag{
-							Name:    "info",
-							Aliases: []string{"i"},
-							Value:   "",
-							Usage:   "Setting info",
+						&cli.IntFlag{
+							Name:    "limit",
+							Aliases: []string{"l"},
+							Value:   environments.DefaultActivityLimit,
+							Usage:   "Number of changes per page",
 						},
 					},
-					Action: cliWrapper(updateSetting),
+					Action: cliWrapper(activityEnvironment),
 				},
 				{
-					Name:    "delete",
-					Aliases: []string{"d"},
-					Usage:   "Delete an existing configuration value",
+					Name:  "history",
+					Usage: "Show the daily node count history of an environment",
 					Flags: []cli.Flag{
 						&cli.StringFlag{
 							Name:    "name",
 							Aliases: []string{"n"},
-							Usage:   "Value name to be deleted",
+							Usage:   "Environment name to be used",
 						},
 						&cli.StringFlag{
-							Name:    "service",
+							Name:    "metric",
+							Aliases: []string{"m"},
+							Value:   nodes.HistoryActive,
+							Usage:   "Metric to show: total, active, inactive, platform:<name> or version:<osquery version>",
+						},
+						&cli.StringFlag{
+							Name:    "since",
 							Aliases: []string{"s"},
-							Usage:   "Value service to be deleted",
+							Value:   "90d",
+							Usage:   "Show history since a duration ago like 90d, or since a date",
 						},
 					},
-					Action: cliWrapper(deleteSetting),
-				},
-				{
-					Name:    "show",
-					Aliases: []string{"s"},
-					Usage:   "Show all configuration values",
-					Action:  cliWrapper(listConfiguration),
+					Action: cliWrapper(historyEnvironment),
 				},
-			},
-		},
-		{
-			Name:  "node",
-			Usage: "Commands for nodes",
-			Subcommands: []*cli.Command{
 				{
-					Name:    "delete",
-					Aliases: []string{"d"},
-					Usage:   "Delete and archive an existing node",
+					Name:  "storage",
+					Usage: "Show the storage used by an environment by category and its daily samples",
 					Flags: []cli.Flag{
 						&cli.StringFlag{
-							Name:    "uuid, u",
-							Aliases: []string{"u"},
-							Usage:   "Node UUID to be deleted",
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Environment name to be used",
 						},
 						&cli.StringFlag{
-							Name:    "env",
-							Aliases: []string{"e"},
-							Usage:   "Environment to be used",
+							Name:    "since",
+							Aliases: []string{"s"},
+							Value:   "30d",
+							Usage:   "Show daily samples since a duration ago like 30d, or since a date",
 						},
 					},
-					Action: cliWrapper(deleteNode),
+					Action: cliWrapper(storageEnvironment),
 				},
 				{
-					Name:    "tag",
-					Aliases: []string{"t"},
-					Usage:   "Tag an existing node",
+					Name:  "quota",
+					Usage: "Set the soft storage quota of an environment, actions apply while it is exceeded",
 					Flags: []cli.Flag{
 						&cli.StringFlag{
-							Name:    "uuid, u",
-							Aliases: []string{"u"},
-							Usage:   "Node UUID to be tagged",
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Environment name to be used",
 						},
 						&cli.StringFlag{
-							Name:    "env",
-							Aliases: []string{"e"},
-							Usage:   "Environment to be used",
+							Name:    "quota",
+							Aliases: []string{"q"},
+							Usage:   "Quota size like 500MB or 50GB, empty or 0 removes the quota",
 						},
-						&cli.StringFlag{
-							Name:    "tag-value",
-							Aliases: []string{"T"},
-							Usage:   "Tag value to be used. It will be created if does not exist",
+						&cli.StringSliceFlag{
+							Name:    "action",
+							Aliases: []string{"a"},
+							Usage:   "Action when the quota is exceeded: notify, block_carves or pause_always_log",
 						},
 					},
-					Action: cliWrapper(tagNode),
+					Action: cliWrapper(quotaEnvironment),
 				},
 				{
-					Name:    "list",
-					Aliases: []string{"l"},
-					Usage:   "List enrolled nodes",
+					Name:  "coverage",
+					Usage: "Estimate how many nodes are expected to check in within a window, from their check-ins of the last 7 days",
 					Flags: []cli.Flag{
-						&cli.BoolFlag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Environment name to be used",
+						},
+						&cli.StringFlag{
+							Name:    "window",
+							Aliases: []string{"w"},
+							Usage:   "Window in hours like 24h or days like 2d, the default query expiration if empty",
+						},
+						&cli.StringSliceFlag{
+							Name:  "target",
+							Usage: "Target nodes by UUID or hostname, all nodes if empty",
+						},
+						&cli.StringSliceFlag{
+							Name:  "platform",
+							Usage: "Target nodes by platform",
+						},
+					},
+					Action: cliWrapper(coverageEnvironment),
+				},
+				{
+					Name:  "enroll-loops",
+					Usage: "Show the hosts of an environment enrolling in a loop, with hints at the cause",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Environment name to be used",
+						},
+					},
+					Action: cliWrapper(enrollLoopsEnvironment),
+				},
+				{
+					Name:  "query-expiration",
+					Usage: "Set the default expiration in hours of the queries of an environment",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Environment name to be used",
+						},
+						&cli.IntFlag{
+							Name:  "hours",
+							Value: 0,
+							Usage: "Default expiration in hours, 0 for the global default",
+						},
+					},
+					Action: cliWrapper(queryExpirationEnvironment),
+				},
+				{
+					Name:  "pseudonyms",
+					Usage: "Set the columns and queries pseudonymized in the logs sent by an environment, empty to disable it",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Environment name to be used",
+						},
+						&cli.StringFlag{
+							Name:  "columns",
+							Value: "",
+							Usage: "Comma separated patterns of columns to pseudonymize, like username,*_user",
+						},
+						&cli.StringFlag{
+							Name:  "tables",
+							Value: "",
+							Usage: "Comma separated patterns of queries with all their columns pseudonymized",
+						},
+					},
+					Action: cliWrapper(pseudonymsEnvironment),
+				},
+				{
+					Name:  "pseudonym-lookup",
+					Usage: "Re-identify a pseudonym found in the logs of an environment, the lookup is recorded",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Environment name to be used",
+						},
+						&cli.StringFlag{
+							Name:    "pseudonym",
+							Aliases: []string{"p"},
+							Usage:   "Pseudonym to re-identify",
+						},
+					},
+					Action: cliWrapper(pseudonymLookupEnvironment),
+				},
+				{
+					Name:  "enroll-fields",
+					Usage: "List the enroll metadata fields of an environment",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Environment name to be used",
+						},
+					},
+					Action: cliWrapper(listEnrollFields),
+				},
+				{
+					Name:  "set-enroll-field",
+					Usage: "Create or update an enroll metadata field, passed by installers as OSCTRL_META_<FIELD>",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Environment name to be used",
+						},
+						&cli.StringFlag{
+							Name:    "field",
+							Aliases: []string{"f"},
+							Usage:   "Field name, lowercase letters, digits and underscores",
+						},
+						&cli.StringFlag{
+							Name:    "description",
+							Aliases: []string{"d"},
+							Usage:   "Field description",
+						},
+						&cli.StringFlag{
+							Name:    "regex",
+							Aliases: []string{"r"},
+							Usage:   "Regular expression that values must match",
+						},
+						&cli.BoolFlag{
+							Name:    "required",
+							Aliases: []string{"R"},
+							Usage:   "Nodes without the field are not enrolled",
+						},
+					},
+					Action: cliWrapper(setEnrollField),
+				},
+				{
+					Name:  "delete-enroll-field",
+					Usage: "Delete an enroll metadata field, values stored in nodes are kept",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Environment name to be used",
+						},
+						&cli.StringFlag{
+							Name:    "field",
+							Aliases: []string{"f"},
+							Usage:   "Field name to be deleted",
+						},
+					},
+					Action: cliWrapper(deleteEnrollField),
+				},
+				{
+					Name:  "pack-source",
+					Usage: "Show the pack source of an environment with its synced packs",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Environment name to be used",
+						},
+					},
+					Action: cliWrapper(showPackSource),
+				},
+				{
+					Name:  "set-pack-source",
+					Usage: "Set the Git repository where packs of an environment are synced from",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Environment name to be used",
+						},
+						&cli.StringFlag{
+							Name:    "url",
+							Aliases: []string{"u"},
+							Usage:   "Repository URL, HTTPS or SSH",
+						},
+						&cli.StringFlag{
+							Name:    "branch",
+							Aliases: []string{"b"},
+							Value:   "main",
+							Usage:   "Branch to sync packs from",
+						},
+						&cli.StringFlag{
+							Name:    "path-glob",
+							Aliases: []string{"g"},
+							Value:   environments.DefaultPackGlob,
+							Usage:   "Glob for pack files in the repository",
+						},
+						&cli.StringFlag{
+							Name:    "auth",
+							Aliases: []string{"a"},
+							Value:   environments.PackAuthNone,
+							Usage:   "Authentication to the repository (none, token or ssh)",
+						},
+						&cli.StringFlag{
+							Name:    "credential",
+							Aliases: []string{"c"},
+							Usage:   "Reference to the credential, env:OSCTRL_PACK_NAME or file:name in the credentials directory of osctrl-admin",
+						},
+						&cli.IntFlag{
+							Name:    "interval",
+							Aliases: []string{"i"},
+							Value:   environments.DefaultPackSyncEvery,
+							Usage:   "Minutes between syncs",
+						},
+						&cli.BoolFlag{
+							Name:    "enabled",
+							Aliases: []string{"e"},
+							Value:   true,
+							Usage:   "Sync packs periodically from the repository",
+						},
+					},
+					Action: cliWrapper(setPackSource),
+				},
+				{
+					Name:  "sync-packs",
+					Usage: "Sync the packs of an environment from its repository in the background",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Environment name to be used",
+						},
+					},
+					Action: cliWrapper(syncPacks),
+				},
+				{
+					Name:  "resolve-pack",
+					Usage: "Choose if the repository or the manual version wins for a synced pack",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Environment name to be used",
+						},
+						&cli.StringFlag{
+							Name:    "pack",
+							Aliases: []string{"p"},
+							Usage:   "Pack name to be resolved",
+						},
+						&cli.StringFlag{
+							Name:    "resolution",
+							Aliases: []string{"r"},
+							Usage:   "Version that wins (repo or manual), empty to ask again on conflicts",
+						},
+					},
+					Action: cliWrapper(resolvePack),
+				},
+				{
+					Name:  "delete-pack-source",
+					Usage: "Delete the pack source of an environment, synced packs are kept",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Environment name to be used",
+						},
+					},
+					Action: cliWrapper(deletePackSource),
+				},
+				{
+					Name:  "profiles",
+					Usage: "List the performance profiles of an environment",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Environment name to be used",
+						},
+					},
+					Action: cliWrapper(listProfiles),
+				},
+				{
+					Name:  "set-profile",
+					Usage: "Create or update a performance profile with the check-in intervals of a class of nodes",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Environment name to be used",
+						},
+						&cli.StringFlag{
+							Name:    "profile",
+							Aliases: []string{"p"},
+							Usage:   "Profile name, lowercase letters, digits, dashes and underscores",
+						},
+						&cli.IntFlag{
+							Name:  "priority",
+							Value: 0,
+							Usage: "Profiles with lower priority are matched first",
+						},
+						&cli.StringFlag{
+							Name:    "tag",
+							Aliases: []string{"t"},
+							Usage:   "Nodes with this tag get the profile",
+						},
+						&cli.StringFlag{
+							Name:  "platform",
+							Usage: "Rule to match nodes by platform",
+						},
+						&cli.StringFlag{
+							Name:  "hardware-model",
+							Usage: "Rule to match nodes with a hardware model containing this value",
+						},
+						&cli.IntFlag{
+							Name:  "config",
+							Value: 0,
+							Usage: "Value for config_refresh in seconds, 0 keeps the environment value",
+						},
+						&cli.IntFlag{
+							Name:  "log",
+							Value: 0,
+							Usage: "Value for logger_tls_period in seconds, 0 keeps the environment value",
+						},
+						&cli.IntFlag{
+							Name:  "query",
+							Value: 0,
+							Usage: "Value for distributed_interval in seconds, 0 keeps the environment value",
+						},
+					},
+					Action: cliWrapper(setProfile),
+				},
+				{
+					Name:  "delete-profile",
+					Usage: "Delete a performance profile, its nodes fall back to the environment intervals",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Environment name to be used",
+						},
+						&cli.StringFlag{
+							Name:    "profile",
+							Aliases: []string{"p"},
+							Usage:   "Profile name to be deleted",
+						},
+					},
+					Action: cliWrapper(deleteProfile),
+				},
+				{
+					Name:  "denylist",
+					Usage: "List the active scheduled query denylist of an environment",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Environment name to be used",
+						},
+					},
+					Action: cliWrapper(listDenylist),
+				},
+				{
+					Name:  "deny",
+					Usage: "Remove a query or pack from the configuration served to nodes, wherever it is defined",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Environment name to be used",
+						},
+						&cli.StringFlag{
+							Name:    "pack",
+							Aliases: []string{"p"},
+							Usage:   "Pack to deny, the whole pack without query",
+						},
+						&cli.StringFlag{
+							Name:    "query",
+							Aliases: []string{"q"},
+							Usage:   "Query to deny, from the schedule and every pack without pack",
+						},
+						&cli.StringFlag{
+							Name:  "platform",
+							Usage: "Deny only for nodes of this platform",
+						},
+						&cli.StringFlag{
+							Name:    "tag",
+							Aliases: []string{"t"},
+							Usage:   "Deny only for nodes with this tag",
+						},
+						&cli.StringFlag{
+							Name:    "reason",
+							Aliases: []string{"r"},
+							Usage:   "Why the query or pack is denied",
+						},
+						&cli.IntFlag{
+							Name:  "hours",
+							Value: 0,
+							Usage: "Hours until the entry is lifted, 0 never expires",
+						},
+					},
+					Action: cliWrapper(addDeny),
+				},
+				{
+					Name:  "lift-deny",
+					Usage: "Lift a denylist entry before it expires",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Environment name to be used",
+						},
+						&cli.UintFlag{
+							Name:  "id",
+							Usage: "Denylist entry ID to be lifted",
+						},
+					},
+					Action: cliWrapper(liftDeny),
+				},
+				{
+					Name:  "quick-actions",
+					Usage: "List the quick actions of an environment",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Environment name to be used",
+						},
+					},
+					Action: cliWrapper(listQuickActions),
+				},
+				{
+					Name:  "set-quick-action",
+					Usage: "Create or update a quick action for nodes, queries only from approved templates",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Environment name to be used",
+						},
+						&cli.StringFlag{
+							Name:    "action",
+							Aliases: []string{"a"},
+							Usage:   "Quick action name, lowercase letters, digits, dashes and underscores",
+						},
+						&cli.StringFlag{
+							Name:    "label",
+							Aliases: []string{"l"},
+							Usage:   "Label of the button of the quick action",
+						},
+						&cli.StringFlag{
+							Name:  "icon",
+							Usage: "Icon of the button of the quick action, like fas fa-bolt",
+						},
+						&cli.StringFlag{
+							Name:    "type",
+							Aliases: []string{"t"},
+							Value:   queries.QuickActionQuery,
+							Usage:   "Type of quick action: query, carve, tag or accelerate",
+						},
+						&cli.StringFlag{
+							Name:  "template",
+							Usage: "Approved query template to run, for query actions",
+						},
+						&cli.StringFlag{
+							Name:  "variables",
+							Usage: "Preset values of the variables of the template, as name=value separated by commas",
+						},
+						&cli.StringFlag{
+							Name:  "path",
+							Usage: "Path to carve, for carve actions",
+						},
+						&cli.StringFlag{
+							Name:  "tag",
+							Usage: "Tag to apply, for tag actions",
+						},
+					},
+					Action: cliWrapper(setQuickAction),
+				},
+				{
+					Name:  "delete-quick-action",
+					Usage: "Delete a quick action, its versions are kept",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Environment name to be used",
+						},
+						&cli.StringFlag{
+							Name:    "action",
+							Aliases: []string{"a"},
+							Usage:   "Quick action name to be deleted",
+						},
+					},
+					Action: cliWrapper(deleteQuickAction),
+				},
+				{
+					Name:  "run-quick-action",
+					Usage: "Run a quick action against nodes of an environment, or preview what it would run",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Environment name to be used",
+						},
+						&cli.StringFlag{
+							Name:    "action",
+							Aliases: []string{"a"},
+							Usage:   "Quick action name to be run",
+						},
+						&cli.StringSliceFlag{
+							Name:    "uuid",
+							Aliases: []string{"u"},
+							Usage:   "UUID of the node to run the quick action against, can be repeated",
+						},
+						&cli.BoolFlag{
+							Name:  "preview",
+							Usage: "Show what the quick action would run without running it",
+						},
+						&cli.BoolFlag{
+							Name:  "confirm-notices",
+							Usage: "Confirm the critical notices of the environment that require it",
+						},
+					},
+					Action: cliWrapper(runQuickAction),
+				},
+				{
+					Name:  "windows-events",
+					Usage: "List the Windows event channels of an environment with the flags they generate",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Environment name to be used",
+						},
+					},
+					Action: cliWrapper(listWindowsEvents),
+				},
+				{
+					Name:  "set-windows-event",
+					Usage: "Create, update, enable or disable a Windows event channel collected from Windows nodes",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Environment name to be used",
+						},
+						&cli.StringFlag{
+							Name:    "channel",
+							Aliases: []string{"c"},
+							Usage:   "Channel name, like Microsoft-Windows-Sysmon/Operational",
+						},
+						&cli.BoolFlag{
+							Name:  "disable",
+							Usage: "Disable the channel, removing its flag and its query",
+						},
+						&cli.IntFlag{
+							Name:  "interval",
+							Value: 0,
+							Usage: "Interval of the query in seconds, 0 uses the default",
+						},
+						&cli.IntFlag{
+							Name:  "max-rows",
+							Value: 0,
+							Usage: "Maximum events returned in each interval, 0 returns all events",
+						},
+					},
+					Action: cliWrapper(setWindowsEvent),
+				},
+				{
+					Name:  "delete-windows-event",
+					Usage: "Delete a Windows event channel",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Environment name to be used",
+						},
+						&cli.StringFlag{
+							Name:    "channel",
+							Aliases: []string{"c"},
+							Usage:   "Channel name to be deleted",
+						},
+					},
+					Action: cliWrapper(deleteWindowsEvent),
+				},
+				{
+					Name:  "export-windows-events",
+					Usage: "Export the Windows event channels of an environment as JSON",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Environment name to be used",
+						},
+						&cli.StringFlag{
+							Name:    "output",
+							Aliases: []string{"o"},
+							Usage:   "File to write the channels, stdout by default",
+						},
+					},
+					Action: cliWrapper(exportWindowsEvents),
+				},
+				{
+					Name:  "import-windows-events",
+					Usage: "Replace all the Windows event channels of an environment with exported ones",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Environment name to be used",
+						},
+						&cli.StringFlag{
+							Name:    "input",
+							Aliases: []string{"i"},
+							Usage:   "File with the exported channels",
+						},
+					},
+					Action: cliWrapper(importWindowsEvents),
+				},
+				{
+					Name:  "apikey",
+					Usage: "Commands for service keys of an environment, for API access without a user",
+					Subcommands: []*cli.Command{
+						{
+							Name:    "list",
+							Aliases: []string{"l"},
+							Usage:   "List the service keys of an environment with their usage",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "name",
+									Aliases: []string{"n"},
+									Usage:   "Environment name to be used",
+								},
+							},
+							Action: cliWrapper(listAPIKeys),
+						},
+						{
+							Name:    "add",
+							Aliases: []string{"a"},
+							Usage:   "Create a service key, the key is only shown once",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "name",
+									Aliases: []string{"n"},
+									Usage:   "Environment name to be used",
+								},
+								&cli.StringFlag{
+									Name:    "key",
+									Aliases: []string{"k"},
+									Usage:   "Name of the key, used to attribute its requests",
+								},
+								&cli.StringSliceFlag{
+									Name:    "scopes",
+									Aliases: []string{"s"},
+									Usage:   "Scopes of the key, comma separated: " + strings.Join(users.APIKeyScopes, ","),
+								},
+								&cli.IntFlag{
+									Name:    "days",
+									Aliases: []string{"d"},
+									Value:   users.DefaultAPIKeyDays,
+									Usage:   "Days until the key expires, negative for no expiration",
+								},
+							},
+							Action: cliWrapper(addAPIKey),
+						},
+						{
+							Name:  "rotate",
+							Usage: "Issue a new key with the same name and scopes, previous keys keep working during the overlap",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "name",
+									Aliases: []string{"n"},
+									Usage:   "Environment name to be used",
+								},
+								&cli.StringFlag{
+									Name:    "key",
+									Aliases: []string{"k"},
+									Usage:   "Name of the key to rotate",
+								},
+								&cli.IntFlag{
+									Name:    "days",
+									Aliases: []string{"d"},
+									Value:   users.DefaultAPIKeyDays,
+									Usage:   "Days until the new key expires, negative for no expiration",
+								},
+								&cli.IntFlag{
+									Name:    "overlap",
+									Aliases: []string{"o"},
+									Value:   int(users.DefaultAPIKeyOverlap / time.Hour),
+									Usage:   "Hours that previous keys keep working",
+								},
+							},
+							Action: cliWrapper(rotateAPIKey),
+						},
+						{
+							Name:  "revoke",
+							Usage: "Revoke a service key",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "name",
+									Aliases: []string{"n"},
+									Usage:   "Environment name to be used",
+								},
+								&cli.StringFlag{
+									Name:  "id",
+									Usage: "Key ID to be revoked",
+								},
+							},
+							Action: cliWrapper(revokeAPIKey),
+						},
+					},
+				},
+				{
+					Name:  "config",
+					Usage: "Commands for the configuration versions of an environment",
+					Subcommands: []*cli.Command{
+						{
+							Name:  "history",
+							Usage: "List the configuration versions of an environment, newest first",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "name",
+									Aliases: []string{"n"},
+									Usage:   "Environment name to be used",
+								},
+							},
+							Action: cliWrapper(configHistory),
+						},
+						{
+							Name:  "diff",
+							Usage: "Show the differences of the configuration between two versions",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "name",
+									Aliases: []string{"n"},
+									Usage:   "Environment name to be used",
+								},
+								&cli.IntFlag{
+									Name:  "from",
+									Value: 0,
+									Usage: "Version to compare from, 0 uses the version before the latest",
+								},
+								&cli.IntFlag{
+									Name:  "to",
+									Value: 0,
+									Usage: "Version to compare to, 0 uses the latest",
+								},
+							},
+							Action: cliWrapper(configDiff),
+						},
+						{
+							Name:  "rollback",
+							Usage: "Restore the configuration of a version, saved as a new version",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "name",
+									Aliases: []string{"n"},
+									Usage:   "Environment name to be used",
+								},
+								&cli.IntFlag{
+									Name:    "version",
+									Aliases: []string{"v"},
+									Usage:   "Version to be restored",
+								},
+								&cli.StringFlag{
+									Name:  "note",
+									Usage: "Note for the new version",
+								},
+							},
+							Action: cliWrapper(configRollback),
+						},
+						{
+							Name:  "apply-at",
+							Usage: "Schedule a change of one section of the configuration to be applied at a time, as a new version",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "name",
+									Aliases: []string{"n"},
+									Usage:   "Environment name to be used",
+								},
+								&cli.StringFlag{
+									Name:    "section",
+									Aliases: []string{"s"},
+									Usage:   "Section of the configuration to change: options, schedule, packs, decorators, atc, flags or configuration",
+								},
+								&cli.StringFlag{
+									Name:    "input",
+									Aliases: []string{"i"},
+									Usage:   "File with the new value of the section",
+								},
+								&cli.StringFlag{
+									Name:  "at",
+									Usage: "Time to apply the change, in RFC3339 like 2006-01-02T15:04:05Z",
+								},
+								&cli.StringFlag{
+									Name:  "note",
+									Usage: "Note for the maintenance window",
+								},
+								&cli.BoolFlag{
+									Name:  "replace",
+									Usage: "Replace the change already scheduled, if any",
+								},
+							},
+							Action: cliWrapper(configApplyAt),
+						},
+						{
+							Name:  "cancel-scheduled",
+							Usage: "Cancel the pending scheduled change of the configuration",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "name",
+									Aliases: []string{"n"},
+									Usage:   "Environment name to be used",
+								},
+							},
+							Action: cliWrapper(configCancelScheduled),
+						},
+						{
+							Name:  "verify",
+							Usage: "Verify the flags and configuration served against osquery versions, every version of the nodes by default",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "name",
+									Aliases: []string{"n"},
+									Usage:   "Environment name to be used",
+								},
+								&cli.StringFlag{
+									Name:  "version",
+									Usage: "Osquery version to verify against, like 5.9.1",
+								},
+								&cli.StringFlag{
+									Name:  "platform",
+									Usage: "Platform to render the configuration for",
+								},
+								&cli.StringFlag{
+									Name:  "profile",
+									Usage: "Performance profile to render the configuration with",
+								},
+								&cli.StringFlag{
+									Name:    "section",
+									Aliases: []string{"s"},
+									Usage:   "Section of the configuration to change before verifying: options, schedule, packs, decorators, atc, flags or configuration",
+								},
+								&cli.StringFlag{
+									Name:    "input",
+									Aliases: []string{"i"},
+									Usage:   "File with the new value of the section",
+								},
+							},
+							Action: cliWrapper(configVerify),
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:  "settings",
+			Usage: "Commands for settings",
+			Subcommands: []*cli.Command{
+				{
+					Name:    "add",
+					Aliases: []string{"a"},
+					Usage:   "Add a new settings value",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Value name to be added",
+						},
+						&cli.StringFlag{
+							Name:    "service",
+							Aliases: []string{"s"},
+							Usage:   "Value service to be added",
+						},
+						&cli.StringFlag{
+							Name:    "type, t",
+							Aliases: []string{"t"},
+							Usage:   "Value type to be added",
+						},
+						&cli.StringFlag{
+							Name:  "string",
+							Value: "",
+							Usage: "Value string",
+						},
+						&cli.Int64Flag{
+							Name:  "integer",
+							Value: 0,
+							Usage: "Value integer",
+						},
+						&cli.BoolFlag{
+							Name:   "boolean",
+							Hidden: false,
+							Usage:  "Value boolean",
+						},
+						&cli.StringFlag{
+							Name:    "info",
+							Aliases: []string{"i"},
+							Value:   "",
+							Usage:   "Setting info",
+						},
+					},
+					Action: cliWrapper(addSetting),
+				},
+				{
+					Name:    "update",
+					Aliases: []string{"u"},
+					Usage:   "Update a configuration value",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Value name to be updated",
+						},
+						&cli.StringFlag{
+							Name:    "service",
+							Aliases: []string{"s"},
+							Usage:   "Value service to be updated",
+						},
+						&cli.StringFlag{
+							Name:    "type",
+							Aliases: []string{"t"},
+							Usage:   "Value type to be updated",
+						},
+						&cli.StringFlag{
+							Name:  "string",
+							Value: "",
+							Usage: "Value string",
+						},
+						&cli.Int64Flag{
+							Name:  "integer",
+							Value: 0,
+							Usage: "Value integer",
+						},
+						&cli.BoolFlag{
+							Name:   "true",
+							Hidden: false,
+							Usage:  "Value boolean true",
+						},
+						&cli.BoolFlag{
+							Name:   "false",
+							Hidden: false,
+							Usage:  "Value boolean false",
+						},
+						&cli.StringFlag{
+							Name:    "info",
+							Aliases: []string{"i"},
+							Value:   "",
+							Usage:   "Setting info",
+						},
+					},
+					Action: cliWrapper(updateSetting),
+				},
+				{
+					Name:    "delete",
+					Aliases: []string{"d"},
+					Usage:   "Delete an existing configuration value",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Value name to be deleted",
+						},
+						&cli.StringFlag{
+							Name:    "service",
+							Aliases: []string{"s"},
+							Usage:   "Value service to be deleted",
+						},
+					},
+					Action: cliWrapper(deleteSetting),
+				},
+				{
+					Name:    "show",
+					Aliases: []string{"s"},
+					Usage:   "Show all configuration values",
+					Action:  cliWrapper(listConfiguration),
+				},
+			},
+		},
+		{
+			Name:  "node",
+			Usage: "Commands for nodes",
+			Subcommands: []*cli.Command{
+				{
+					Name:      "find",
+					Aliases:   []string{"f"},
+					Usage:     "Find nodes in all environments by the start of their UUID, hostname, IP address or serial",
+					ArgsUsage: "<term>",
+					Action:    cliWrapper(findNodes),
+				},
+				{
+					Name:    "delete",
+					Aliases: []string{"d"},
+					Usage:   "Delete and archive an existing node",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "uuid, u",
+							Aliases: []string{"u"},
+							Usage:   "Node UUID to be deleted",
+						},
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+					},
+					Action: cliWrapper(deleteNode),
+				},
+				{
+					Name:    "decommission",
+					Aliases: []string{"D"},
+					Usage:   "Decommission an existing node, osquery is removed and the node archived once it stops contacting",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "uuid",
+							Aliases: []string{"u"},
+							Usage:   "Node UUID to be decommissioned",
+						},
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+					},
+					Action: cliWrapper(decommissionNode),
+				},
+				{
+					Name:  "pause",
+					Usage: "Pause an existing node, it keeps sending logs but does not get queries nor carves",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "uuid",
+							Aliases: []string{"u"},
+							Usage:   "Node UUID to be paused",
+						},
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+						&cli.StringFlag{
+							Name:    "reason",
+							Aliases: []string{"r"},
+							Usage:   "Reason to pause the node",
+						},
+						&cli.StringFlag{
+							Name:    "expires",
+							Aliases: []string{"x"},
+							Usage:   "Expiration of the pause as a date, RFC3339 or a duration like 48h or 3d, empty to not expire",
+						},
+					},
+					Action: cliWrapper(pauseNode),
+				},
+				{
+					Name:  "unpause",
+					Usage: "Unpause a paused node, the queries still active are served again",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "uuid",
+							Aliases: []string{"u"},
+							Usage:   "Node UUID to be unpaused",
+						},
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+					},
+					Action: cliWrapper(unpauseNode),
+				},
+				{
+					Name:    "decommissions",
+					Aliases: []string{"dl"},
+					Usage:   "List decommissioned nodes, use --state contacting for nodes still contacting after the grace period",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+						&cli.StringFlag{
+							Name:    "state",
+							Aliases: []string{"s"},
+							Usage:   "Filter by state: pending, silent or contacting",
+						},
+					},
+					Action: cliWrapper(listDecommissions),
+				},
+				{
+					Name:    "merge",
+					Aliases: []string{"m"},
+					Usage:   "Merge a duplicate node into the canonical node of the same host, the duplicate is archived",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:  "into",
+							Usage: "UUID of the canonical node, it is kept",
+						},
+						&cli.StringFlag{
+							Name:  "from",
+							Usage: "UUID of the duplicate node, its records are moved to the canonical node",
+						},
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+					},
+					Action: cliWrapper(mergeNode),
+				},
+				{
+					Name:    "merges",
+					Aliases: []string{"ml"},
+					Usage:   "List merges of duplicate nodes, use --state proposed for the ones waiting for review",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+						&cli.StringFlag{
+							Name:    "state",
+							Aliases: []string{"s"},
+							Usage:   "Filter by state: proposed, rejected, merged or split",
+						},
+					},
+					Action: cliWrapper(listMerges),
+				},
+				{
+					Name:    "merge-review",
+					Aliases: []string{"mr"},
+					Usage:   "Review a merge of duplicate nodes: approve, reject or split it back",
+					Flags: []cli.Flag{
+						&cli.UintFlag{
+							Name:  "id",
+							Usage: "ID of the merge",
+						},
+						&cli.StringFlag{
+							Name:    "action",
+							Aliases: []string{"a"},
+							Usage:   "Review action: approve, reject or split",
+						},
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+					},
+					Action: cliWrapper(reviewMerge),
+				},
+				{
+					Name:    "status-token",
+					Aliases: []string{"st"},
+					Usage:   "Generate a short-lived link to the status of a node, to share it with help desk",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "uuid",
+							Aliases: []string{"u"},
+							Usage:   "Node UUID to generate the link for",
+						},
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+						&cli.Int64Flag{
+							Name:  "hours",
+							Value: 0,
+							Usage: "Hours the link is valid, capped by the status_token_max_hours setting",
+						},
+						&cli.StringFlag{
+							Name:  "url",
+							Value: "",
+							Usage: "URL of osctrl-admin to show the full link",
+						},
+					},
+					Action: cliWrapper(statusTokenNode),
+				},
+				{
+					Name:    "tag",
+					Aliases: []string{"t"},
+					Usage:   "Tag an existing node",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "uuid, u",
+							Aliases: []string{"u"},
+							Usage:   "Node UUID to be tagged",
+						},
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+						&cli.StringFlag{
+							Name:    "tag-value",
+							Aliases: []string{"T"},
+							Usage:   "Tag value to be used. It will be created if does not exist",
+						},
+					},
+					Action: cliWrapper(tagNode),
+				},
+				{
+					Name:    "list",
+					Aliases: []string{"l"},
+					Usage:   "List enrolled nodes",
+					Flags: []cli.Flag{
+						&cli.BoolFlag{
+							Name:    "active",
+							Aliases: []string{"a"},
+							Hidden:  false,
+							Value:   true,
+							Usage:   "Show active nodes",
+						},
+						&cli.BoolFlag{
+							Name:    "all, A",
+							Aliases: []string{"A"},
+							Hidden:  false,
+							Usage:   "Show all nodes",
+						},
+						&cli.BoolFlag{
+							Name:    "inactive, i",
+							Aliases: []string{"i"},
+							Hidden:  false,
+							Usage:   "Show inactive nodes",
+						},
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+						&cli.StringSliceFlag{
+							Name:    "meta",
+							Aliases: []string{"m"},
+							Usage:   "Filter by enroll metadata, as name:value",
+						},
+					},
+					Action: cliWrapper(listNodes),
+				},
+				{
+					Name:    "show",
+					Aliases: []string{"s"},
+					Usage:   "Show an existing node",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "uuid",
+							Aliases: []string{"u"},
+							Usage:   "Node UUID to be shown",
+						},
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+					},
+					Action: cliWrapper(showNode),
+				},
+				{
+					Name:    "network-history",
+					Aliases: []string{"n"},
+					Usage:   "Show the network history of an existing node",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "uuid",
+							Aliases: []string{"u"},
+							Usage:   "Node UUID to be shown",
+						},
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+					},
+					Action: cliWrapper(showNodeNetworkHistory),
+				},
+				{
+					Name:  "membership",
+					Usage: "Show the environments and tags of an existing node at one point in time, or their changes within a window",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "uuid",
+							Aliases: []string{"u"},
+							Usage:   "Node UUID to be shown",
+						},
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+						&cli.StringFlag{
+							Name:  "at",
+							Usage: "Point in time as a date, RFC3339 or a duration ago like 90d, now by default",
+						},
+						&cli.StringFlag{
+							Name:  "from",
+							Usage: "Start of the window of changes, as a date, RFC3339 or a duration ago",
+						},
+						&cli.StringFlag{
+							Name:  "to",
+							Usage: "End of the window of changes, as a date, RFC3339 or a duration ago, now by default",
+						},
+					},
+					Action: cliWrapper(showNodeMembership),
+				},
+			},
+		},
+		{
+			Name:  "query",
+			Usage: "Commands for queries",
+			Subcommands: []*cli.Command{
+				{
+					Name:    "complete",
+					Aliases: []string{"c"},
+					Usage:   "Mark an on-demand query as completed",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Query name to be completed",
+						},
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+					},
+					Action: cliWrapper(completeQuery),
+				},
+				{
+					Name:    "delete",
+					Aliases: []string{"d"},
+					Usage:   "Move an on-demand query to the trash",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Query name to be deleted",
+						},
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+						&cli.BoolFlag{
+							Name:  "hard",
+							Value: false,
+							Usage: "Delete permanently instead of moving to the trash, only for admins",
+						},
+					},
+					Action: cliWrapper(deleteQuery),
+				},
+				{
+					Name:    "run",
+					Aliases: []string{"r"},
+					Usage:   "Start a new on-demand query",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "query",
+							Aliases: []string{"q"},
+							Usage:   "Query to be issued",
+						},
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+						&cli.StringFlag{
+							Name:    "uuid",
+							Aliases: []string{"u"},
+							Usage:   "Node UUID to be used",
+						},
+						&cli.BoolFlag{
+							Name:    "hidden",
+							Aliases: []string{"x"},
+							Hidden:  false,
+							Usage:   "Mark query as hidden",
+						},
+						&cli.BoolFlag{
+							Name:    "profile",
+							Aliases: []string{"p"},
+							Hidden:  false,
+							Usage:   "Run as no-op trial in a sample of nodes",
+						},
+						&cli.IntFlag{
+							Name:    "sample",
+							Aliases: []string{"s"},
+							Value:   10,
+							Usage:   "Number of nodes to sample in a profile run",
+						},
+						&cli.StringFlag{
+							Name:    "template",
+							Aliases: []string{"t"},
+							Usage:   "Query template to be used instead of a query",
+						},
+						&cli.StringSliceFlag{
+							Name:  "var",
+							Usage: "Value for a variable of the template, as name=value",
+						},
+						&cli.StringFlag{
+							Name:  "description",
+							Usage: "Why the query is run, for the investigation context",
+						},
+						&cli.StringFlag{
+							Name:  "ticket-id",
+							Usage: "ID of the ticket of the investigation",
+						},
+						&cli.StringFlag{
+							Name:  "ticket-url",
+							Usage: "URL of the ticket of the investigation",
+						},
+						&cli.StringSliceFlag{
+							Name:  "label",
+							Usage: "Label of the query, as key=value",
+						},
+						&cli.StringFlag{
+							Name:  "enrolled-after",
+							Usage: "Target nodes enrolled after this time, in RFC3339",
+						},
+						&cli.StringFlag{
+							Name:  "enrolled-before",
+							Usage: "Target nodes enrolled before this time, in RFC3339",
+						},
+						&cli.StringFlag{
+							Name:  "seen-after",
+							Usage: "Target nodes last seen after this time, in RFC3339",
+						},
+						&cli.StringFlag{
+							Name:  "seen-before",
+							Usage: "Target nodes last seen before this time, in RFC3339",
+						},
+						&cli.StringSliceFlag{
+							Name:  "attribute",
+							Usage: "Target nodes by custom attribute, like vuln.risk_score>7",
+						},
+						&cli.BoolFlag{
+							Name:  "confirm-notices",
+							Usage: "Confirm the critical notices of the environment that require it",
+						},
+						&cli.StringFlag{
+							Name:  "confirm-name",
+							Usage: "Name of the environment typed to confirm launches its category asks to confirm",
+						},
+						&cli.StringFlag{
+							Name:  "group",
+							Usage: "Concurrency group to throttle the query with related queries",
+						},
+						&cli.IntFlag{
+							Name:  "priority",
+							Value: 0,
+							Usage: "Priority of the query in its group, lower goes first",
+						},
+						&cli.BoolFlag{
+							Name:  "override",
+							Usage: "Run the query even if it uses tables restricted by query policies, only for admins",
+						},
+						&cli.StringFlag{
+							Name:  "justification",
+							Usage: "Justification to override query policies, it is audited",
+						},
+						&cli.StringFlag{
+							Name:  "expiration",
+							Usage: "Expiration of the query in hours like 48h or days like 2d, the environment default if empty",
+						},
+						&cli.StringFlag{
+							Name:  "visibility",
+							Usage: "Who can see the results of the query: public, creator or team",
+						},
+						&cli.StringFlag{
+							Name:  "team",
+							Usage: "Team that can see the results of the query, with team visibility",
+						},
+					},
+					Action: cliWrapper(runQuery),
+				},
+				{
+					Name:  "archive",
+					Usage: "Show the archive of a query, with the progress of its rehydration",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Query name to be shown",
+						},
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+					},
+					Action: cliWrapper(showQueryArchive),
+				},
+				{
+					Name:  "rehydrate",
+					Usage: "Restore the results of an archived query in the background, until they are evicted again",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Query name to be rehydrated",
+						},
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+					},
+					Action: cliWrapper(rehydrateQuery),
+				},
+				{
+					Name:  "groups",
+					Usage: "Show the concurrency groups of queries with their limits and utilization",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+					},
+					Action: cliWrapper(listQueryGroups),
+				},
+				{
+					Name:  "group-limits",
+					Usage: "Set the concurrency limits of a group of queries, zero for no limit",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Group to be changed",
+						},
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+						&cli.IntFlag{
+							Name:  "per-node",
+							Value: 0,
+							Usage: "Queries of the group in flight at the same time in each node",
+						},
+						&cli.IntFlag{
+							Name:  "total",
+							Value: 0,
+							Usage: "Queries of the group in flight at the same time across all nodes",
+						},
+					},
+					Action: cliWrapper(setQueryGroupLimits),
+				},
+				{
+					Name:  "policies",
+					Usage: "List the query policies of an environment",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+					},
+					Action: cliWrapper(listQueryPolicies),
+				},
+				{
+					Name:  "policy-set",
+					Usage: "Set the tables and columns that a user or service key can query",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "subject",
+							Aliases: []string{"s"},
+							Usage:   "Username, service key as apikey:name or * for everyone else",
+						},
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+						&cli.StringFlag{
+							Name:    "mode",
+							Aliases: []string{"m"},
+							Value:   queries.PolicyDeny,
+							Usage:   "Deny the tables or only allow them (deny or allow)",
+						},
+						&cli.StringFlag{
+							Name:    "tables",
+							Aliases: []string{"t"},
+							Usage:   "Comma separated tables, with * and ? wildcards",
+						},
+						&cli.StringFlag{
+							Name:    "columns",
+							Aliases: []string{"c"},
+							Usage:   "Comma separated table.column patterns always denied",
+						},
+					},
+					Action: cliWrapper(setQueryPolicy),
+				},
+				{
+					Name:  "policy-delete",
+					Usage: "Delete the query policy of a user or service key",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "subject",
+							Aliases: []string{"s"},
+							Usage:   "Username, service key as apikey:name or * for everyone else",
+						},
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+					},
+					Action: cliWrapper(deleteQueryPolicy),
+				},
+				{
+					Name:  "context",
+					Usage: "Replace the investigation context of a query",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Query name to be changed",
+						},
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+						&cli.StringFlag{
+							Name:  "description",
+							Usage: "Why the query is run, for the investigation context",
+						},
+						&cli.StringFlag{
+							Name:  "ticket-id",
+							Usage: "ID of the ticket of the investigation",
+						},
+						&cli.StringFlag{
+							Name:  "ticket-url",
+							Usage: "URL of the ticket of the investigation",
+						},
+						&cli.StringSliceFlag{
+							Name:  "label",
+							Usage: "Label of the query, as key=value",
+						},
+					},
+					Action: cliWrapper(queryContext),
+				},
+				{
+					Name:  "share",
+					Usage: "Export queries with their redacted results as a ZIP to share with external responders",
+					Flags: []cli.Flag{
+						&cli.StringSliceFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Query name to be shared, can be repeated",
+						},
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+						&cli.StringFlag{
+							Name:    "format",
+							Aliases: []string{"f"},
+							Value:   queries.ExportJSON,
+							Usage:   "Format of the results, json or csv",
+						},
+						&cli.BoolFlag{
+							Name:  "drop-ips",
+							Usage: "Replace IP addresses in results",
+						},
+						&cli.StringSliceFlag{
+							Name:  "deny-column",
+							Usage: "Pattern of columns of results to drop, like *user*, can be repeated. Defaults to usernames",
+						},
+						&cli.StringFlag{
+							Name:    "output",
+							Aliases: []string{"w"},
+							Usage:   "File to write the ZIP",
+						},
+					},
+					Action: cliWrapper(shareQuery),
+				},
+				{
+					Name:  "export",
+					Usage: "Export the results of a query with typed columns, large results are materialized by an export job and downloaded in ranges",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Query name to be exported",
+						},
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+						&cli.StringFlag{
+							Name:    "format",
+							Aliases: []string{"f"},
+							Value:   queries.ExportCSV,
+							Usage:   "Format of the results, " + strings.Join(queries.ExportFormats, ", "),
+						},
+						&cli.StringFlag{
+							Name:    "output",
+							Aliases: []string{"w"},
+							Usage:   "File to write the results",
+						},
+						&cli.BoolFlag{
+							Name:  "resume",
+							Usage: "Use an export job to download the results in ranges, continuing the output if it exists, and verify its SHA-256",
+						},
+						&cli.IntFlag{
+							Name:  "retries",
+							Value: 5,
+							Usage: "Times to retry downloads of export jobs from where they stopped",
+						},
+					},
+					Action: cliWrapper(exportQuery),
+				},
+				{
+					Name:  "profile",
+					Usage: "Show the report of a profile run",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Profile query name",
+						},
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+					},
+					Action: cliWrapper(profileQuery),
+				},
+				{
+					Name:  "launch",
+					Usage: "Launch in all targets the query of a profile run",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Profile query name",
+						},
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+					},
+					Action: cliWrapper(launchQuery),
+				},
+				{
+					Name:    "list",
+					Aliases: []string{"l"},
+					Usage:   "List on-demand queries",
+					Flags: []cli.Flag{
+						&cli.BoolFlag{
+							Name:    "all",
+							Aliases: []string{"v"},
+							Hidden:  true,
+							Usage:   "Show all queries",
+						},
+						&cli.BoolFlag{
+							Name:    "active",
+							Aliases: []string{"a"},
+							Hidden:  false,
+							Usage:   "Show active queries",
+						},
+						&cli.BoolFlag{
+							Name:    "completed, c",
+							Aliases: []string{"c"},
+							Hidden:  false,
+							Usage:   "Show completed queries",
+						},
+						&cli.BoolFlag{
+							Name:    "deleted",
+							Aliases: []string{"d"},
+							Hidden:  false,
+							Usage:   "Show deleted queries",
+						},
+						&cli.BoolFlag{
+							Name:    "hidden",
+							Aliases: []string{"x"},
+							Hidden:  false,
+							Usage:   "Show hidden queries",
+						},
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+						&cli.StringFlag{
+							Name:  "label",
+							Usage: "Show queries with this label, as key or key=value",
+						},
+						&cli.StringFlag{
+							Name:  "ticket",
+							Usage: "Show queries of this ticket ID or URL",
+						},
+					},
+					Action: cliWrapper(listQueries),
+				},
+			},
+		},
+		{
+			Name:  "template",
+			Usage: "Commands for query templates",
+			Subcommands: []*cli.Command{
+				{
+					Name:    "add",
+					Aliases: []string{"a"},
+					Usage:   "Add a new query template",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Template name",
+						},
+						&cli.StringFlag{
+							Name:    "description",
+							Aliases: []string{"d"},
+							Usage:   "Template description",
+						},
+						&cli.StringFlag{
+							Name:    "query",
+							Aliases: []string{"q"},
+							Usage:   "Template SQL, with variables as {{name}}",
+						},
+						&cli.StringSliceFlag{
+							Name:  "var",
+							Usage: "Variable of the template, as name:string, name:int or name:enum:value1,value2",
+						},
+						&cli.StringFlag{
+							Name:    "platforms",
+							Aliases: []string{"p"},
+							Usage:   "Comma separated list of supported platforms, all platforms if empty",
+						},
+						&cli.StringFlag{
+							Name:    "risk",
+							Aliases: []string{"r"},
+							Value:   queries.TemplateRiskLow,
+							Usage:   "Risk level of the template (low, medium or high)",
+						},
+						&cli.StringFlag{
+							Name:    "team",
+							Aliases: []string{"t"},
+							Usage:   "Team that owns the template",
+						},
+						&cli.BoolFlag{
+							Name:  "approved",
+							Usage: "Approve the template to run it even if it uses tables restricted by query policies",
+						},
+						&cli.StringFlag{
+							Name:    "changelog",
+							Aliases: []string{"c"},
+							Usage:   "Changelog of this version of the template",
+						},
+					},
+					Action: cliWrapper(addTemplate),
+				},
+				{
+					Name:    "update",
+					Aliases: []string{"u"},
+					Usage:   "Update a query template as a new version, all values are replaced",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Template name",
+						},
+						&cli.StringFlag{
+							Name:    "description",
+							Aliases: []string{"d"},
+							Usage:   "Template description",
+						},
+						&cli.StringFlag{
+							Name:    "query",
+							Aliases: []string{"q"},
+							Usage:   "Template SQL, with variables as {{name}}",
+						},
+						&cli.StringSliceFlag{
+							Name:  "var",
+							Usage: "Variable of the template, as name:string, name:int or name:enum:value1,value2",
+						},
+						&cli.StringFlag{
+							Name:    "platforms",
+							Aliases: []string{"p"},
+							Usage:   "Comma separated list of supported platforms, all platforms if empty",
+						},
+						&cli.StringFlag{
+							Name:    "risk",
+							Aliases: []string{"r"},
+							Value:   queries.TemplateRiskLow,
+							Usage:   "Risk level of the template (low, medium or high)",
+						},
+						&cli.StringFlag{
+							Name:    "team",
+							Aliases: []string{"t"},
+							Usage:   "Team that owns the template",
+						},
+						&cli.BoolFlag{
+							Name:  "approved",
+							Usage: "Approve the template to run it even if it uses tables restricted by query policies",
+						},
+						&cli.StringFlag{
+							Name:    "changelog",
+							Aliases: []string{"c"},
+							Usage:   "Changelog of this version of the template",
+						},
+					},
+					Action: cliWrapper(updateTemplate),
+				},
+				{
+					Name:    "delete",
+					Aliases: []string{"d"},
+					Usage:   "Move a query template to the trash, its versions are kept",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Template name to be deleted",
+						},
+						&cli.BoolFlag{
+							Name:  "hard",
+							Value: false,
+							Usage: "Delete permanently instead of moving to the trash, only for admins",
+						},
+					},
+					Action: cliWrapper(deleteTemplate),
+				},
+				{
+					Name:    "list",
+					Aliases: []string{"l"},
+					Usage:   "List all query templates",
+					Action:  cliWrapper(listTemplates),
+				},
+				{
+					Name:    "versions",
+					Aliases: []string{"v"},
+					Usage:   "Show the versions of a query template with the changelog",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Template name to be displayed",
+						},
+					},
+					Action: cliWrapper(showTemplateVersions),
+				},
+			},
+		},
+		{
+			Name:  "carve",
+			Usage: "Commands for file carves",
+			Subcommands: []*cli.Command{
+				{
+					Name:    "complete",
+					Aliases: []string{"c"},
+					Usage:   "Mark an file carve as completed",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Carve name to be completed",
+						},
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+					},
+					Action: cliWrapper(completeCarve),
+				},
+				{
+					Name:    "delete",
+					Aliases: []string{"d"},
+					Usage:   "Move a file carve to the trash",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Carve name to be deleted",
+						},
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+						&cli.BoolFlag{
+							Name:  "hard",
+							Value: false,
+							Usage: "Delete permanently instead of moving to the trash, only for admins",
+						},
+					},
+					Action: cliWrapper(deleteCarve),
+				},
+				{
+					Name:    "scan",
+					Aliases: []string{"s"},
+					Usage:   "Scan again a completed file carve",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "session",
+							Aliases: []string{"s"},
+							Usage:   "Session of the carved file to be scanned",
+						},
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+					},
+					Action: cliWrapper(scanCarve),
+				},
+				{
+					Name:    "run",
+					Aliases: []string{"r"},
+					Usage:   "Start a new carve for a file or a directory",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "path",
+							Aliases: []string{"p"},
+							Usage:   "File or directory path to be carved",
+						},
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+						&cli.StringFlag{
+							Name:    "uuid",
+							Aliases: []string{"u"},
+							Usage:   "Node UUID to be used",
+						},
+						&cli.StringFlag{
+							Name:  "enrolled-after",
+							Usage: "Target nodes enrolled after this time, in RFC3339",
+						},
+						&cli.StringFlag{
+							Name:  "enrolled-before",
+							Usage: "Target nodes enrolled before this time, in RFC3339",
+						},
+						&cli.StringFlag{
+							Name:  "seen-after",
+							Usage: "Target nodes last seen after this time, in RFC3339",
+						},
+						&cli.StringFlag{
+							Name:  "seen-before",
+							Usage: "Target nodes last seen before this time, in RFC3339",
+						},
+						&cli.StringSliceFlag{
+							Name:  "attribute",
+							Usage: "Target nodes by custom attribute, like vuln.risk_score>7",
+						},
+						&cli.BoolFlag{
+							Name:  "confirm-notices",
+							Usage: "Confirm the critical notices of the environment that require it",
+						},
+						&cli.StringFlag{
+							Name:  "confirm-name",
+							Usage: "Name of the environment typed to confirm launches its category asks to confirm",
+						},
+						&cli.StringFlag{
+							Name:  "visibility",
+							Usage: "Who can see the carved files: public, creator or team",
+						},
+						&cli.StringFlag{
+							Name:  "team",
+							Usage: "Team that can see the carved files, with team visibility",
+						},
+					},
+					Action: cliWrapper(runCarve),
+				},
+				{
+					Name:    "list",
+					Aliases: []string{"l"},
+					Usage:   "List file carves",
+					Flags: []cli.Flag{
+						&cli.BoolFlag{
+							Name:    "all",
+							Aliases: []string{"v"},
+							Hidden:  true,
+							Usage:   "Show all carves",
+						},
+						&cli.BoolFlag{
 							Name:    "active",
 							Aliases: []string{"a"},
 							Hidden:  false,
-							Value:   true,
-							Usage:   "Show active nodes",
+							Usage:   "Show active carves",
 						},
 						&cli.BoolFlag{
-							Name:    "all, A",
-							Aliases: []string{"A"},
+							Name:    "completed, c",
+							Aliases: []string{"c"},
 							Hidden:  false,
-							Usage:   "Show all nodes",
+							Usage:   "Show completed carves",
 						},
 						&cli.BoolFlag{
-							Name:    "inactive, i",
-							Aliases: []string{"i"},
+							Name:    "deleted",
+							Aliases: []string{"d"},
 							Hidden:  false,
-							Usage:   "Show inactive nodes",
+							Usage:   "Show deleted carves",
+						},
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+					},
+					Action: cliWrapper(listCarves),
+				},
+				{
+					Name:    "recurring",
+					Aliases: []string{"r"},
+					Usage:   "Manage carves launched on a recurrence for legal holds",
+					Subcommands: []*cli.Command{
+						{
+							Name:  "create",
+							Usage: "Create a carve launched in the same nodes on a recurrence, until the end date or stopped",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:  "case",
+									Usage: "Case or hold identifier to tag the carves",
+								},
+								&cli.StringSliceFlag{
+									Name:    "path",
+									Aliases: []string{"p"},
+									Usage:   "Path to be carved, can be repeated",
+								},
+								&cli.StringSliceFlag{
+									Name:    "uuid",
+									Aliases: []string{"u"},
+									Usage:   "Node UUID to carve, can be repeated",
+								},
+								&cli.StringFlag{
+									Name:    "recurrence",
+									Aliases: []string{"r"},
+									Value:   "24h",
+									Usage:   "Interval like 24h or cron expression in UTC like \"0 2 * * *\"",
+								},
+								&cli.StringFlag{
+									Name:  "end",
+									Usage: "End date in RFC3339, empty to run until stopped",
+								},
+								&cli.StringFlag{
+									Name:    "env",
+									Aliases: []string{"e"},
+									Usage:   "Environment to be used",
+								},
+							},
+							Action: cliWrapper(createRecurringCarve),
+						},
+						{
+							Name:  "list",
+							Usage: "List recurring carves with the storage used by each case",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "env",
+									Aliases: []string{"e"},
+									Usage:   "Environment to be used",
+								},
+							},
+							Action: cliWrapper(listRecurringCarves),
+						},
+						{
+							Name:  "pause",
+							Usage: "Pause a recurring carve until it is resumed",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "name",
+									Aliases: []string{"n"},
+									Usage:   "Recurring carve name",
+								},
+								&cli.StringFlag{
+									Name:    "env",
+									Aliases: []string{"e"},
+									Usage:   "Environment to be used",
+								},
+							},
+							Action: cliWrapper(pauseRecurringCarve),
+						},
+						{
+							Name:  "resume",
+							Usage: "Resume a paused recurring carve",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "name",
+									Aliases: []string{"n"},
+									Usage:   "Recurring carve name",
+								},
+								&cli.StringFlag{
+									Name:    "env",
+									Aliases: []string{"e"},
+									Usage:   "Environment to be used",
+								},
+							},
+							Action: cliWrapper(resumeRecurringCarve),
+						},
+						{
+							Name:  "stop",
+							Usage: "Stop a recurring carve, the carves already collected are kept",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "name",
+									Aliases: []string{"n"},
+									Usage:   "Recurring carve name",
+								},
+								&cli.StringFlag{
+									Name:    "env",
+									Aliases: []string{"e"},
+									Usage:   "Environment to be used",
+								},
+							},
+							Action: cliWrapper(stopRecurringCarve),
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:  "tag",
+			Usage: "Commands for tags",
+			Subcommands: []*cli.Command{
+				{
+					Name:    "add",
+					Aliases: []string{"a"},
+					Usage:   "Add a new tag",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Tage name to be added",
+						},
+						&cli.StringFlag{
+							Name:    "color",
+							Aliases: []string{"c"},
+							Value:   "",
+							Usage:   "Tag color to be added",
+						},
+						&cli.StringFlag{
+							Name:    "description, d",
+							Aliases: []string{"d"},
+							Usage:   "Tag description to be added",
+						},
+						&cli.StringFlag{
+							Name:    "icon, i",
+							Aliases: []string{"i"},
+							Value:   "",
+							Usage:   "Tag icon to be added",
+						},
+					},
+					Action: cliWrapper(addTag),
+				},
+				{
+					Name:    "edit",
+					Aliases: []string{"e"},
+					Usage:   "Edit values for an existing tag",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Tage name to be edited",
+						},
+						&cli.StringFlag{
+							Name:    "color",
+							Aliases: []string{"c"},
+							Usage:   "Tag color to be edited",
+						},
+						&cli.StringFlag{
+							Name:    "description",
+							Aliases: []string{"d"},
+							Usage:   "Tag description to be edited",
+						},
+						&cli.StringFlag{
+							Name:    "icon",
+							Aliases: []string{"i"},
+							Usage:   "Tag icon to be edited",
+						},
+					},
+					Action: cliWrapper(editTag),
+				},
+				{
+					Name:    "delete",
+					Aliases: []string{"d"},
+					Usage:   "Delete an existing tag",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Tag name to be deleted",
+						},
+					},
+					Action: cliWrapper(deleteTag),
+				},
+				{
+					Name:    "list",
+					Aliases: []string{"l"},
+					Usage:   "List all tags",
+					Action:  cliWrapper(listTags),
+				},
+				{
+					Name:    "show",
+					Aliases: []string{"s"},
+					Usage:   "Show an existing tag",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Tag name to be displayed",
 						},
+					},
+					Action: cliWrapper(showTag),
+				},
+			},
+		},
+		{
+			Name:  "report",
+			Usage: "Commands for fleet reports",
+			Subcommands: []*cli.Command{
+				{
+					Name:    "generate",
+					Aliases: []string{"g"},
+					Usage:   "Generate a new fleet report",
+					Flags: []cli.Flag{
 						&cli.StringFlag{
 							Name:    "env",
 							Aliases: []string{"e"},
-							Usage:   "Environment to be used",
+							Usage:   "Environment to be used, all environments if empty",
+						},
+						&cli.StringFlag{
+							Name:  "category",
+							Usage: "Category of the environments of a report of all environments: production, staging, development, lab or custom",
+						},
+						&cli.StringFlag{
+							Name:    "period",
+							Aliases: []string{"p"},
+							Value:   reports.PeriodLastWeek,
+							Usage:   "Period of the report, last-week or last-month",
+						},
+						&cli.StringFlag{
+							Name:    "format",
+							Aliases: []string{"f"},
+							Value:   reports.FormatHTML,
+							Usage:   "Format of the report, html or pdf",
+						},
+						&cli.StringFlag{
+							Name:    "output",
+							Aliases: []string{"w"},
+							Usage:   "File to write the generated report",
+						},
+						&cli.StringFlag{
+							Name:  "pdf",
+							Usage: "Command to convert reports to PDF, only with DB access (wkhtmltopdf --quiet - -)",
 						},
 					},
-					Action: cliWrapper(listNodes),
+					Action: cliWrapper(generateReport),
 				},
 				{
-					Name:    "show",
-					Aliases: []string{"s"},
-					Usage:   "Show an existing node",
-					Flags: []cli.Flag{
-						&cli.StringFlag{
-							Name:    "uuid",
-							Aliases: []string{"u"},
-							Usage:   "Node UUID to be shown",
+					Name:    "list",
+					Aliases: []string{"l"},
+					Usage:   "List all generated reports",
+					Action:  cliWrapper(listReports),
+				},
+			},
+		},
+		{
+			Name:  "logs",
+			Usage: "Commands for logs",
+			Subcommands: []*cli.Command{
+				{
+					Name:  "dlq",
+					Usage: "Commands for dead letters of logs that the logger backend did not accept",
+					Subcommands: []*cli.Command{
+						{
+							Name:    "list",
+							Aliases: []string{"l"},
+							Usage:   "Show the counts by reason and the latest dead letters",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "backend",
+									Aliases: []string{"b"},
+									Usage:   "Backend that failed the delivery, like splunk or kinesis",
+								},
+								&cli.StringFlag{
+									Name:    "reason",
+									Aliases: []string{"r"},
+									Usage:   "Reason of the failed delivery, like rejected or payload-too-large",
+								},
+								&cli.StringFlag{
+									Name:    "env",
+									Aliases: []string{"e"},
+									Usage:   "Environment of the logs",
+								},
+								&cli.IntFlag{
+									Name:    "limit",
+									Aliases: []string{"l"},
+									Value:   25,
+									Usage:   "Maximum dead letters to show",
+								},
+							},
+							Action: cliWrapper(listDeadLetters),
+						},
+						{
+							Name:  "replay",
+							Usage: "Replay dead letters through osctrl-tls, to their backend or to --target",
+							Flags: append(deadLetterFlags(), &cli.StringFlag{
+								Name:    "target",
+								Aliases: []string{"t"},
+								Usage:   "Backend to replay to, the backend of osctrl-tls or db for the always logger",
+							}),
+							Action: cliWrapper(replayDeadLetters),
+						},
+						{
+							Name:   "purge",
+							Usage:  "Purge dead letters",
+							Flags:  deadLetterFlags(),
+							Action: cliWrapper(purgeDeadLetters),
 						},
+					},
+				},
+				{
+					Name:  "import-s3",
+					Usage: "Import status and result logs written by the S3 logger into the DB logger, it can run again to resume",
+					Flags: []cli.Flag{
 						&cli.StringFlag{
 							Name:    "env",
 							Aliases: []string{"e"},
-							Usage:   "Environment to be used",
+							Usage:   "Environment of the logs",
+						},
+						&cli.StringFlag{
+							Name:    "s3-config",
+							Aliases: []string{"S"},
+							Usage:   "Configuration file of the S3 logger, for bucket, region and credentials",
+						},
+						&cli.StringFlag{
+							Name:    "bucket",
+							Aliases: []string{"b"},
+							Usage:   "Bucket with the logs, overrides the S3 configuration",
+						},
+						&cli.StringFlag{
+							Name:  "region",
+							Usage: "Region of the bucket, overrides the S3 configuration",
+						},
+						&cli.StringFlag{
+							Name:    "prefix",
+							Aliases: []string{"p"},
+							Usage:   "Prefix of the objects, the environment by default",
+						},
+						&cli.StringFlag{
+							Name:  "from",
+							Usage: "First day of logs to import, as YYYY-MM-DD",
+						},
+						&cli.StringFlag{
+							Name:  "to",
+							Usage: "Last day of logs to import, as YYYY-MM-DD",
+						},
+						&cli.IntFlag{
+							Name:  "retention",
+							Value: logging.DefaultS3ImportRetention,
+							Usage: "Days of logs to keep, older events are skipped, 0 imports all",
+						},
+						&cli.IntFlag{
+							Name:  "batch",
+							Value: logging.DefaultS3ImportBatch,
+							Usage: "Entries inserted in each batch",
+						},
+						&cli.StringFlag{
+							Name:    "checkpoint",
+							Aliases: []string{"c"},
+							Value:   "s3-import.json",
+							Usage:   "File to save the progress and resume the import",
+						},
+						&cli.StringFlag{
+							Name:  "logger-db",
+							Usage: "Configuration file of the DB logger, when it does not use the osctrl DB",
 						},
 					},
-					Action: cliWrapper(showNode),
+					Action: cliWrapper(importS3Logs),
 				},
 			},
 		},
 		{
-			Name:  "query",
-			Usage: "Commands for queries",
+			Name:  "response",
+			Usage: "Commands for response actions, queries and carves launched when triggers fire",
 			Subcommands: []*cli.Command{
 				{
-					Name:    "complete",
-					Aliases: []string{"c"},
-					Usage:   "Mark an on-demand query as completed",
+					Name:    "list",
+					Aliases: []string{"l"},
+					Usage:   "List the latest response actions of an environment with their status",
 					Flags: []cli.Flag{
 						&cli.StringFlag{
-							Name:    "name",
-							Aliases: []string{"n"},
-							Usage:   "Query name to be completed",
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+						&cli.IntFlag{
+							Name:  "limit",
+							Value: 50,
+							Usage: "Maximum actions to show, 0 shows all",
 						},
+					},
+					Action: cliWrapper(listResponseActions),
+				},
+				{
+					Name:  "config",
+					Usage: "Show the response actions configuration of an environment",
+					Flags: []cli.Flag{
 						&cli.StringFlag{
 							Name:    "env",
 							Aliases: []string{"e"},
 							Usage:   "Environment to be used",
 						},
 					},
-					Action: cliWrapper(completeQuery),
+					Action: cliWrapper(showResponseConfig),
 				},
 				{
-					Name:    "delete",
-					Aliases: []string{"d"},
-					Usage:   "Mark an on-demand query as deleted",
+					Name:  "set-config",
+					Usage: "Enable or disable response actions in an environment, with the allowed actions and the limits",
 					Flags: []cli.Flag{
 						&cli.StringFlag{
-							Name:    "name",
-							Aliases: []string{"n"},
-							Usage:   "Query name to be deleted",
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+						&cli.BoolFlag{
+							Name:  "enable",
+							Usage: "Enable response actions, they are disabled without it",
+						},
+						&cli.StringFlag{
+							Name:  "allowed",
+							Value: "",
+							Usage: "Allowed actions, comma separated: query,carve",
+						},
+						&cli.IntFlag{
+							Name:  "cooldown",
+							Value: responses.DefaultCooldown,
+							Usage: "Seconds before a trigger fires again for the same node",
 						},
+						&cli.IntFlag{
+							Name:  "max-per-hour",
+							Value: responses.DefaultMaxPerHour,
+							Usage: "Maximum actions per hour, the circuit breaker opens when reached",
+						},
+						&cli.BoolFlag{
+							Name:  "reset",
+							Usage: "Reset the circuit breaker, the rest of the flags are ignored",
+						},
+					},
+					Action: cliWrapper(setResponseConfig),
+				},
+				{
+					Name:  "triggers",
+					Usage: "List the response triggers of an environment",
+					Flags: []cli.Flag{
 						&cli.StringFlag{
 							Name:    "env",
 							Aliases: []string{"e"},
 							Usage:   "Environment to be used",
 						},
 					},
-					Action: cliWrapper(deleteQuery),
+					Action: cliWrapper(listResponseTriggers),
 				},
 				{
-					Name:    "run",
-					Aliases: []string{"r"},
-					Usage:   "Start a new on-demand query",
+					Name:  "set-trigger",
+					Usage: "Create or update a response trigger",
 					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Trigger name",
+						},
 						&cli.StringFlag{
 							Name:    "query",
 							Aliases: []string{"q"},
-							Usage:   "Query to be issued",
+							Usage:   "Scheduled query whose results fire the trigger, empty to fire only with alerts",
+						},
+						&cli.StringFlag{
+							Name:  "column",
+							Usage: "Column of the results to match, any row matches without it",
+						},
+						&cli.StringFlag{
+							Name:  "value",
+							Usage: "Value of the column to match",
+						},
+						&cli.StringFlag{
+							Name:    "kind",
+							Aliases: []string{"k"},
+							Value:   responses.KindQuery,
+							Usage:   "Action to launch: query or carve",
+						},
+						&cli.StringFlag{
+							Name:    "template",
+							Aliases: []string{"t"},
+							Usage:   "Query template to run in the node, for query actions",
+						},
+						&cli.StringSliceFlag{
+							Name:  "var",
+							Usage: "Variable of the template as name=value, with {{field}} for fields of the event",
 						},
+						&cli.StringFlag{
+							Name:    "path",
+							Aliases: []string{"p"},
+							Usage:   "Path to carve, with {{field}} for fields of the event, for carve actions",
+						},
+						&cli.BoolFlag{
+							Name:  "disable",
+							Usage: "Disable the trigger",
+						},
+					},
+					Action: cliWrapper(setResponseTrigger),
+				},
+				{
+					Name:  "delete-trigger",
+					Usage: "Delete a response trigger, recorded actions are kept",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Trigger name",
+						},
+					},
+					Action: cliWrapper(deleteResponseTrigger),
+				},
+				{
+					Name:  "alert",
+					Usage: "Fire a response trigger for one node with the fields of an external alert",
+					Flags: []cli.Flag{
 						&cli.StringFlag{
 							Name:    "env",
 							Aliases: []string{"e"},
 							Usage:   "Environment to be used",
 						},
+						&cli.StringFlag{
+							Name:  "trigger",
+							Usage: "Trigger to fire",
+						},
 						&cli.StringFlag{
 							Name:    "uuid",
 							Aliases: []string{"u"},
-							Usage:   "Node UUID to be used",
+							Usage:   "Node UUID to launch the action",
 						},
-						&cli.BoolFlag{
-							Name:    "hidden",
-							Aliases: []string{"x"},
-							Hidden:  false,
-							Usage:   "Mark query as hidden",
+						&cli.StringSliceFlag{
+							Name:  "field",
+							Usage: "Field of the alert as name=value",
 						},
 					},
-					Action: cliWrapper(runQuery),
+					Action: cliWrapper(sendResponseAlert),
 				},
 				{
-					Name:    "list",
-					Aliases: []string{"l"},
-					Usage:   "List on-demand queries",
+					Name:  "mutes",
+					Usage: "List the mutes of notifications of an environment, with the suppressed events",
 					Flags: []cli.Flag{
-						&cli.BoolFlag{
-							Name:    "all",
-							Aliases: []string{"v"},
-							Hidden:  true,
-							Usage:   "Show all queries",
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
 						},
-						&cli.BoolFlag{
-							Name:    "active",
-							Aliases: []string{"a"},
-							Hidden:  false,
-							Usage:   "Show active queries",
+					},
+					Action: cliWrapper(listMutes),
+				},
+				{
+					Name:  "mute",
+					Usage: "Mute notifications for a node or for the whole environment until the mute expires",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
 						},
-						&cli.BoolFlag{
-							Name:    "completed, c",
-							Aliases: []string{"c"},
-							Hidden:  false,
-							Usage:   "Show completed queries",
+						&cli.StringFlag{
+							Name:    "kind",
+							Aliases: []string{"k"},
+							Value:   responses.MuteResponses,
+							Usage:   "Notifications to mute: responses, identity_mismatch or storage_quota",
+						},
+						&cli.StringFlag{
+							Name:  "rule",
+							Usage: "Response trigger to mute, all triggers without it",
+						},
+						&cli.StringFlag{
+							Name:    "uuid",
+							Aliases: []string{"u"},
+							Usage:   "Node UUID to mute, the whole environment without it",
+						},
+						&cli.StringFlag{
+							Name:    "reason",
+							Aliases: []string{"r"},
+							Usage:   "Why notifications are muted, it is required",
+						},
+						&cli.IntFlag{
+							Name:  "hours",
+							Value: 24,
+							Usage: "Hours until the mute expires",
 						},
 						&cli.BoolFlag{
-							Name:    "deleted",
-							Aliases: []string{"d"},
-							Hidden:  false,
-							Usage:   "Show deleted queries",
+							Name:  "permanent",
+							Usage: "Mute without expiration, the API only allows it to admins",
 						},
 						&cli.BoolFlag{
-							Name:    "hidden",
-							Aliases: []string{"x"},
-							Hidden:  false,
-							Usage:   "Show hidden queries",
+							Name:  "notify",
+							Usage: "Notify when the mute expires",
 						},
+					},
+					Action: cliWrapper(setMute),
+				},
+				{
+					Name:  "unmute",
+					Usage: "Lift a mute before it expires",
+					Flags: []cli.Flag{
 						&cli.StringFlag{
 							Name:    "env",
 							Aliases: []string{"e"},
 							Usage:   "Environment to be used",
 						},
+						&cli.UintFlag{
+							Name:  "id",
+							Usage: "Mute id to lift",
+						},
 					},
-					Action: cliWrapper(listQueries),
+					Action: cliWrapper(deleteMute),
 				},
 			},
 		},
 		{
-			Name:  "carve",
-			Usage: "Commands for file carves",
+			Name:  "webhook",
+			Usage: "Commands for webhook endpoints, notified when queries and carves complete and response actions fire",
 			Subcommands: []*cli.Command{
 				{
-					Name:    "complete",
-					Aliases: []string{"c"},
-					Usage:   "Mark an file carve as completed",
+					Name:    "list",
+					Aliases: []string{"l"},
+					Usage:   "List the webhook endpoints of an environment",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+					},
+					Action: cliWrapper(listWebhooks),
+				},
+				{
+					Name:    "create",
+					Aliases: []string{"c"},
+					Usage:   "Create a webhook endpoint, its secret is only shown once",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Endpoint name",
+						},
+						&cli.StringFlag{
+							Name:    "url",
+							Aliases: []string{"u"},
+							Usage:   "URL to send the events",
+						},
+						&cli.StringSliceFlag{
+							Name:  "event",
+							Usage: "Event to subscribe: query.completed, carve.completed or response.fired, all events without it",
+						},
+					},
+					Action: cliWrapper(createWebhook),
+				},
+				{
+					Name:  "rotate",
+					Usage: "Rotate the secret of a webhook endpoint, the new secret is only shown once",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Endpoint name",
+						},
+					},
+					Action: cliWrapper(rotateWebhook),
+				},
+				{
+					Name:  "enable",
+					Usage: "Enable a webhook endpoint",
 					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
 						&cli.StringFlag{
 							Name:    "name",
 							Aliases: []string{"n"},
-							Usage:   "Carve name to be completed",
+							Usage:   "Endpoint name",
 						},
+					},
+					Action: cliWrapper(enableWebhook),
+				},
+				{
+					Name:  "disable",
+					Usage: "Disable a webhook endpoint, its pending deliveries fail",
+					Flags: []cli.Flag{
 						&cli.StringFlag{
 							Name:    "env",
 							Aliases: []string{"e"},
 							Usage:   "Environment to be used",
 						},
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Endpoint name",
+						},
 					},
-					Action: cliWrapper(completeCarve),
+					Action: cliWrapper(disableWebhook),
 				},
 				{
 					Name:    "delete",
 					Aliases: []string{"d"},
-					Usage:   "Mark a file carve as deleted",
+					Usage:   "Delete a webhook endpoint",
 					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
+						},
 						&cli.StringFlag{
 							Name:    "name",
 							Aliases: []string{"n"},
-							Usage:   "Carve name to be deleted",
+							Usage:   "Endpoint name",
 						},
+					},
+					Action: cliWrapper(deleteWebhook),
+				},
+				{
+					Name:  "test",
+					Usage: "Send a test event to a webhook endpoint",
+					Flags: []cli.Flag{
 						&cli.StringFlag{
 							Name:    "env",
 							Aliases: []string{"e"},
 							Usage:   "Environment to be used",
 						},
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Endpoint name",
+						},
 					},
-					Action: cliWrapper(deleteCarve),
+					Action: cliWrapper(testWebhook),
 				},
 				{
-					Name:    "run",
-					Aliases: []string{"r"},
-					Usage:   "Start a new carve for a file or a directory",
+					Name:  "deliveries",
+					Usage: "List the deliveries of a webhook endpoint",
 					Flags: []cli.Flag{
-						&cli.StringFlag{
-							Name:    "path",
-							Aliases: []string{"p"},
-							Usage:   "File or directory path to be carved",
-						},
 						&cli.StringFlag{
 							Name:    "env",
 							Aliases: []string{"e"},
 							Usage:   "Environment to be used",
 						},
 						&cli.StringFlag{
-							Name:    "uuid",
-							Aliases: []string{"u"},
-							Usage:   "Node UUID to be used",
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Endpoint name",
+						},
+						&cli.StringFlag{
+							Name:  "state",
+							Usage: "Show only deliveries in this state: pending, delivered or failed",
+						},
+						&cli.IntFlag{
+							Name:  "limit",
+							Value: 50,
+							Usage: "Maximum deliveries to show, 0 shows all",
 						},
 					},
-					Action: cliWrapper(runCarve),
+					Action: cliWrapper(listWebhookDeliveries),
 				},
 				{
-					Name:    "list",
-					Aliases: []string{"l"},
-					Usage:   "List file carves",
+					Name:  "redeliver",
+					Usage: "Queue again a delivery of a webhook endpoint",
 					Flags: []cli.Flag{
-						&cli.BoolFlag{
-							Name:    "all",
-							Aliases: []string{"v"},
-							Hidden:  true,
-							Usage:   "Show all carves",
-						},
-						&cli.BoolFlag{
-							Name:    "active",
-							Aliases: []string{"a"},
-							Hidden:  false,
-							Usage:   "Show active carves",
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to be used",
 						},
-						&cli.BoolFlag{
-							Name:    "completed, c",
-							Aliases: []string{"c"},
-							Hidden:  false,
-							Usage:   "Show completed carves",
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "Endpoint name",
 						},
-						&cli.BoolFlag{
-							Name:    "deleted",
-							Aliases: []string{"d"},
-							Hidden:  false,
-							Usage:   "Show deleted carves",
+						&cli.UintFlag{
+							Name:  "id",
+							Usage: "Delivery ID",
 						},
+					},
+					Action: cliWrapper(redeliverWebhook),
+				},
+				{
+					Name:  "history",
+					Usage: "Show the webhook attempts for a query, carve or response action",
+					Flags: []cli.Flag{
 						&cli.StringFlag{
 							Name:    "env",
 							Aliases: []string{"e"},
 							Usage:   "Environment to be used",
 						},
+						&cli.StringFlag{
+							Name:  "entity",
+							Value: webhooks.EntityQuery,
+							Usage: "Entity type: query, carve, response or endpoint",
+						},
+						&cli.StringFlag{
+							Name:  "id",
+							Usage: "Entity ID, like the query name or the carve session",
+						},
 					},
-					Action: cliWrapper(listCarves),
+					Action: cliWrapper(webhookHistory),
 				},
 			},
 		},
 		{
-			Name:  "tag",
-			Usage: "Commands for tags",
+			Name:  "notice",
+			Usage: "Commands for notices shown as banners to users",
 			Subcommands: []*cli.Command{
+				{
+					Name:    "list",
+					Aliases: []string{"l"},
+					Usage:   "List the active notices",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Environment to list only the notices shown in it",
+						},
+						&cli.BoolFlag{
+							Name:  "history",
+							Usage: "List also the expired and removed notices",
+						},
+					},
+					Action: cliWrapper(listNotices),
+				},
 				{
 					Name:    "add",
 					Aliases: []string{"a"},
-					Usage:   "Add a new tag",
+					Usage:   "Add a new notice",
 					Flags: []cli.Flag{
 						&cli.StringFlag{
-							Name:    "name",
-							Aliases: []string{"n"},
-							Usage:   "Tage name to be added",
+							Name:    "message",
+							Aliases: []string{"m"},
+							Usage:   "Message of the notice",
 						},
 						&cli.StringFlag{
-							Name:    "color",
-							Aliases: []string{"c"},
-							Value:   "",
-							Usage:   "Tag color to be added",
+							Name:    "severity",
+							Aliases: []string{"s"},
+							Usage:   "Severity of the notice, " + strings.Join(users.NoticeSeverities, ", "),
 						},
 						&cli.StringFlag{
-							Name:    "description, d",
-							Aliases: []string{"d"},
-							Usage:   "Tag description to be added",
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Comma separated environments where the notice is shown, empty for all of them",
 						},
 						&cli.StringFlag{
-							Name:    "icon, i",
-							Aliases: []string{"i"},
-							Value:   "",
-							Usage:   "Tag icon to be added",
+							Name:  "expires",
+							Usage: "Expiration of the notice, as a date, date and time in UTC or duration like 48h or 3d, empty for never",
+						},
+						&cli.BoolFlag{
+							Name:  "require-ack",
+							Usage: "Users must acknowledge the notice",
+						},
+						&cli.BoolFlag{
+							Name:  "confirm",
+							Usage: "Require a confirmation to run queries and carves, only for critical notices of environments",
 						},
 					},
-					Action: cliWrapper(addTag),
+					Action: cliWrapper(addNotice),
 				},
 				{
-					Name:    "edit",
-					Aliases: []string{"e"},
-					Usage:   "Edit values for an existing tag",
+					Name:    "update",
+					Aliases: []string{"u"},
+					Usage:   "Update an existing notice",
 					Flags: []cli.Flag{
+						&cli.UintFlag{
+							Name:  "id",
+							Usage: "ID of the notice to be updated",
+						},
 						&cli.StringFlag{
-							Name:    "name",
-							Aliases: []string{"n"},
-							Usage:   "Tage name to be edited",
+							Name:    "message",
+							Aliases: []string{"m"},
+							Usage:   "Message of the notice",
 						},
 						&cli.StringFlag{
-							Name:    "color",
-							Aliases: []string{"c"},
-							Usage:   "Tag color to be edited",
+							Name:    "severity",
+							Aliases: []string{"s"},
+							Usage:   "Severity of the notice, " + strings.Join(users.NoticeSeverities, ", "),
 						},
 						&cli.StringFlag{
-							Name:    "description",
-							Aliases: []string{"d"},
-							Usage:   "Tag description to be edited",
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Comma separated environments where the notice is shown, empty for all of them",
 						},
 						&cli.StringFlag{
-							Name:    "icon",
-							Aliases: []string{"i"},
-							Usage:   "Tag icon to be edited",
+							Name:  "expires",
+							Usage: "Expiration of the notice, as a date, date and time in UTC or duration like 48h or 3d, empty for never",
+						},
+						&cli.BoolFlag{
+							Name:  "require-ack",
+							Usage: "Users must acknowledge the notice",
+						},
+						&cli.BoolFlag{
+							Name:  "confirm",
+							Usage: "Require a confirmation to run queries and carves, only for critical notices of environments",
 						},
 					},
-					Action: cliWrapper(editTag),
+					Action: cliWrapper(updateNotice),
 				},
 				{
 					Name:    "delete",
 					Aliases: []string{"d"},
-					Usage:   "Delete an existing tag",
+					Usage:   "Remove a notice, it is kept in the history",
 					Flags: []cli.Flag{
-						&cli.StringFlag{
-							Name:    "name",
-							Aliases: []string{"n"},
-							Usage:   "Tag name to be deleted",
+						&cli.UintFlag{
+							Name:  "id",
+							Usage: "ID of the notice to be removed",
 						},
 					},
-					Action: cliWrapper(deleteTag),
+					Action: cliWrapper(deleteNotice),
 				},
-				{
-					Name:    "list",
-					Aliases: []string{"l"},
-					Usage:   "List all tags",
-					Action:  cliWrapper(listTags),
+			},
+		},
+		{
+			Name:  "version",
+			Usage: "Show the build of the CLI and of the remote services",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:    "remote",
+					Aliases: []string{"r"},
+					Usage:   "Compare with the build of the remote services",
+				},
+				&cli.StringFlag{
+					Name:  "admin-url",
+					Usage: "URL of osctrl-admin to compare, besides the API",
 				},
+				&cli.StringFlag{
+					Name:  "tls-url",
+					Usage: "URL of osctrl-tls to compare, besides the API",
+				},
+			},
+			Action: showVersion,
+		},
+		{
+			Name:  "cache",
+			Usage: "Commands for the keys of osctrl in redis",
+			Subcommands: []*cli.Command{
 				{
-					Name:    "show",
+					Name:    "stats",
 					Aliases: []string{"s"},
-					Usage:   "Show an existing tag",
-					Flags: []cli.Flag{
+					Usage:   "Show the keys in redis by family, with memory estimated from a sample of keys",
+					Flags: append([]cli.Flag{
+						&cli.IntFlag{
+							Name:  "sample",
+							Value: cache.StatsSample,
+							Usage: "Keys of each family to sample for memory estimates",
+						},
+					}, redisFlags...),
+					Action: cliWrapper(cacheStats),
+				},
+				{
+					Name:    "purge",
+					Aliases: []string{"p"},
+					Usage:   "Remove the keys of a family in batches, like --prefix osctrl:status:*",
+					Flags: append([]cli.Flag{
 						&cli.StringFlag{
-							Name:    "name",
-							Aliases: []string{"n"},
-							Usage:   "Tag name to be displayed",
+							Name:  "prefix",
+							Usage: "Match expression of the keys to remove, within a family of keys of osctrl",
 						},
-					},
-					Action: cliWrapper(showTag),
+						&cli.IntFlag{
+							Name:  "batch",
+							Value: cache.PurgeBatch,
+							Usage: "Keys to remove at once",
+						},
+						&cli.DurationFlag{
+							Name:  "pause",
+							Value: cache.PurgePause,
+							Usage: "Pause between batches, so redis keeps serving other clients",
+						},
+						&cli.BoolFlag{
+							Name:    "yes",
+							Aliases: []string{"y"},
+							Usage:   "Remove keys without confirmation",
+						},
+					}, redisFlags...),
+					Action: cachePurge,
 				},
 			},
 		},
@@ -1446,6 +4432,116 @@ func init() {
 			Usage:  "Checks API token",
 			Action: checkAPI,
 		},
+		{
+			Name:  "backup",
+			Usage: "Commands for encrypted backups of the osctrl state",
+			Subcommands: []*cli.Command{
+				{
+					Name:    "create",
+					Aliases: []string{"c"},
+					Usage:   "Create an encrypted backup from a consistent snapshot of the DB",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "output",
+							Aliases: []string{"o"},
+							Value:   "osctrl-backup.tar.gz",
+							Usage:   "File to write the backup, it must not exist",
+						},
+						&cli.BoolFlag{
+							Name:    "results",
+							Aliases: []string{"r"},
+							Usage:   "Include result, status and query logs, and the content of carves",
+						},
+						&cli.StringFlag{
+							Name:    "passphrase",
+							Aliases: []string{"p"},
+							EnvVars: []string{"OSCTRL_BACKUP_PASSPHRASE"},
+							Usage:   "Passphrase to encrypt the backup",
+						},
+						&cli.StringFlag{
+							Name:  "passphrase-file",
+							Usage: "File with the passphrase to encrypt the backup",
+						},
+						&cli.IntFlag{
+							Name:  "batch",
+							Value: backup.DefaultBatchSize,
+							Usage: "Rows to read at once",
+						},
+					},
+					Action: createBackup,
+				},
+				{
+					Name:    "restore",
+					Aliases: []string{"r"},
+					Usage:   "Restore an encrypted backup into an empty DB",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "input",
+							Aliases: []string{"i"},
+							Usage:   "File with the backup to restore",
+						},
+						&cli.StringFlag{
+							Name:    "passphrase",
+							Aliases: []string{"p"},
+							EnvVars: []string{"OSCTRL_BACKUP_PASSPHRASE"},
+							Usage:   "Passphrase to decrypt the backup",
+						},
+						&cli.StringFlag{
+							Name:  "passphrase-file",
+							Usage: "File with the passphrase to decrypt the backup",
+						},
+						&cli.IntFlag{
+							Name:  "batch",
+							Value: backup.DefaultBatchSize,
+							Usage: "Rows to insert at once",
+						},
+					},
+					Action: restoreBackup,
+				},
+			},
+		},
+		{
+			Name:  "smoke-test",
+			Usage: "Validate a deployment with a synthetic node that enrolls, sends logs and answers a query, using osctrl-tls and the API",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "tls-url",
+					Aliases: []string{"t"},
+					Usage:   "URL of osctrl-tls for the synthetic node",
+				},
+				&cli.StringFlag{
+					Name:    "env",
+					Aliases: []string{"e"},
+					Usage:   "Environment to enroll the synthetic node",
+				},
+				&cli.StringFlag{
+					Name:    "secret",
+					Aliases: []string{"s"},
+					Usage:   "Enroll secret of the environment",
+					EnvVars: []string{"OSCTRL_ENROLL_SECRET"},
+				},
+				&cli.StringFlag{
+					Name:  "hostname-prefix",
+					Value: "osctrl-smoke",
+					Usage: "Prefix for the hostname of the synthetic node",
+				},
+				&cli.DurationFlag{
+					Name:  "timeout",
+					Value: time.Minute,
+					Usage: "Time to wait for queries and results to reach the synthetic node and the API",
+				},
+				&cli.BoolFlag{
+					Name:  "carve",
+					Usage: "Carve a file from the synthetic node too",
+				},
+				&cli.StringFlag{
+					Name:  "carve-path",
+					Value: "/tmp/osctrl-smoke.txt",
+					Usage: "Path of the file carved from the synthetic node",
+				},
+			},
+			Action: smokeTest,
+		},
 		{
 			Name:  "login",
 			Usage: "Login into API and generate JSON config file with token",
@@ -1601,6 +4697,13 @@ func cliWrapper(action func(*cli.Context) error) func(*cli.Context) error {
 			filecarves = carves.CreateFileCarves(db.Conn, settings.CarverDB, nil)
 			// Initialize tags
 			tagsmgr = tags.CreateTagManager(db.Conn)
+			// Initialize reports
+			reportsmgr = reports.CreateReports(db.Conn)
+			// Initialize dead letters
+			deadletters = logging.CreateDeadLetters(db.Conn)
+			// Initialize response actions
+			responsesmgr = responses.CreateResponseManager(db.Conn, queriesmgr)
+			webhooksmgr = webhooks.CreateWebhooks(db.Conn)
 			// Execute action
 			return action(c)
 		}
@@ -1,12 +1,19 @@
 package main
 
 import (
+	"context"
 	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"os"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/jmpsec/osctrl/environments"
 	"github.com/jmpsec/osctrl/nodes"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/users"
 	"github.com/olekukonko/tablewriter"
 	"github.com/urfave/cli/v2"
 )
@@ -29,7 +36,9 @@ func nodeToData(n nodes.OsqueryNode, header []string) [][]string {
 		data = append(data, header)
 	}
 	_n := []string{
+		n.DisplayName,
 		n.Hostname,
+		n.Localname,
 		n.UUID,
 		n.Platform,
 		n.PlatformVersion,
@@ -37,11 +46,23 @@ func nodeToData(n nodes.OsqueryNode, header []string) [][]string {
 		nodeLastSeen(n),
 		n.IPAddress,
 		n.OsqueryVersion,
+		enrollMetadataToString(n),
 	}
 	data = append(data, _n)
 	return data
 }
 
+// Helper function to convert the enroll metadata of a node into name=value pairs
+func enrollMetadataToString(n nodes.OsqueryNode) string {
+	values := nodes.EnrollMetadataValues(n)
+	pairs := make([]string, 0, len(values))
+	for name, value := range values {
+		pairs = append(pairs, name+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, " ")
+}
+
 func listNodes(c *cli.Context) error {
 	// Get flag values for this command
 	target := "active"
@@ -56,6 +77,15 @@ func listNodes(c *cli.Context) error {
 		fmt.Println("❌ environment is required")
 		os.Exit(1)
 	}
+	filters := make(map[string]string)
+	for _, m := range c.StringSlice("meta") {
+		name, value, ok := nodes.ParseMetadataFilter(m)
+		if !ok {
+			fmt.Printf("❌ invalid metadata filter %s, use name:value\n", m)
+			os.Exit(1)
+		}
+		filters[name] = value
+	}
 	// Retrieve data
 	var nds []nodes.OsqueryNode
 	if dbFlag {
@@ -63,14 +93,17 @@ func listNodes(c *cli.Context) error {
 		if err != nil {
 			return fmt.Errorf("error getting nodes - %s", err)
 		}
+		nds = nodes.FilterByEnrollMetadata(nds, filters)
 	} else if apiFlag {
-		nds, err = osctrlAPI.GetNodes(env, target)
+		nds, err = osctrlAPI.GetNodes(env, target, c.StringSlice("meta"))
 		if err != nil {
 			return fmt.Errorf("error getting nodes - %s", err)
 		}
 	}
 	header := []string{
+		"Display Name",
 		"Hostname",
+		"Localname",
 		"UUID",
 		"Platform",
 		"PlatformVersion",
@@ -78,6 +111,7 @@ func listNodes(c *cli.Context) error {
 		"Last Seen",
 		"IPAddress",
 		"OsqueryVersion",
+		"Enroll Metadata",
 	}
 	// Prepare output
 	if formatFlag == jsonFormat {
@@ -120,7 +154,7 @@ func deleteNode(c *cli.Context) error {
 		os.Exit(1)
 	}
 	if dbFlag {
-		if err := nodesmgr.ArchiveDeleteByUUID(uuid); err != nil {
+		if err := nodesmgr.ArchiveDeleteByUUID(uuid, appName); err != nil {
 			return fmt.Errorf("error deleting - %s", err)
 		}
 	} else if apiFlag {
@@ -194,6 +228,10 @@ func showNode(c *cli.Context) error {
 		if err != nil {
 			return fmt.Errorf("error getting node - %s", err)
 		}
+		node.NetworkHistory, err = nodesmgr.GetHistoryIPAddresses(node.UUID)
+		if err != nil {
+			return fmt.Errorf("error getting network history - %s", err)
+		}
 	} else if apiFlag {
 		node, err = osctrlAPI.GetNode(env, uuid)
 		if err != nil {
@@ -201,7 +239,9 @@ func showNode(c *cli.Context) error {
 		}
 	}
 	header := []string{
+		"Display Name",
 		"Hostname",
+		"Localname",
 		"UUID",
 		"Platform",
 		"PlatformVersion",
@@ -209,6 +249,7 @@ func showNode(c *cli.Context) error {
 		"Last Seen",
 		"IPAddress",
 		"OsqueryVersion",
+		"Enroll Metadata",
 	}
 	// Prepare output
 	if formatFlag == jsonFormat {
@@ -232,3 +273,702 @@ func showNode(c *cli.Context) error {
 	}
 	return nil
 }
+
+// Helper function to convert the network history of a node into the data expected for output
+func networkHistoryToData(history []nodes.NodeHistoryIPAddress, header []string) [][]string {
+	var data [][]string
+	if header != nil {
+		data = append(data, header)
+	}
+	for _, h := range history {
+		_h := []string{
+			h.IPAddress,
+			h.Country,
+			h.City,
+			fmt.Sprintf("%d", h.ASN),
+			h.FirstSeen.String(),
+			h.LastSeen.String(),
+			fmt.Sprintf("%d", h.Count),
+		}
+		data = append(data, _h)
+	}
+	return data
+}
+
+func showNodeNetworkHistory(c *cli.Context) error {
+	// Get values from flags
+	uuid := c.String("uuid")
+	if uuid == "" {
+		fmt.Println("❌ UUID is required")
+		os.Exit(1)
+	}
+	env := c.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	var history []nodes.NodeHistoryIPAddress
+	if dbFlag {
+		node, err := nodesmgr.GetByUUID(uuid)
+		if err != nil {
+			return fmt.Errorf("error getting node - %s", err)
+		}
+		history, err = nodesmgr.GetHistoryIPAddresses(node.UUID)
+		if err != nil {
+			return fmt.Errorf("error getting network history - %s", err)
+		}
+	} else if apiFlag {
+		history, err = osctrlAPI.GetNodeNetworkHistory(env, uuid)
+		if err != nil {
+			return fmt.Errorf("error getting network history - %s", err)
+		}
+	}
+	header := []string{
+		"IPAddress",
+		"Country",
+		"City",
+		"ASN",
+		"First Seen",
+		"Last Seen",
+		"Count",
+	}
+	// Prepare output
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(history)
+		if err != nil {
+			return fmt.Errorf("error marshaling - %s", err)
+		}
+		fmt.Println(string(jsonRaw))
+	} else if formatFlag == csvFormat {
+		data := networkHistoryToData(history, header)
+		w := csv.NewWriter(os.Stdout)
+		if err := w.WriteAll(data); err != nil {
+			return fmt.Errorf("error writting csv - %s", err)
+		}
+	} else if formatFlag == prettyFormat {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader(header)
+		data := networkHistoryToData(history, nil)
+		table.AppendBulk(data)
+		table.Render()
+	}
+	return nil
+}
+
+// Helper function to convert membership changes of a node into the data expected for output
+func membershipToData(changes []nodes.NodeMembership, header []string) [][]string {
+	var data [][]string
+	if header != nil {
+		data = append(data, header)
+	}
+	for _, m := range changes {
+		_m := []string{
+			m.CreatedAt.Format(time.RFC3339),
+			m.Entity,
+			m.Name,
+			m.Action,
+			m.Actor,
+		}
+		data = append(data, _m)
+	}
+	return data
+}
+
+func showNodeMembership(c *cli.Context) error {
+	// Get values from flags
+	uuid := c.String("uuid")
+	if uuid == "" {
+		fmt.Println("❌ UUID is required")
+		os.Exit(1)
+	}
+	env := c.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	at := c.String("at")
+	from := c.String("from")
+	to := c.String("to")
+	// Without a window, show the environments and tags at one point in time
+	if from == "" && to == "" {
+		var state nodes.MembershipState
+		if dbFlag {
+			atTime, err := environments.ParseSince(at, time.Now())
+			if err != nil {
+				return err
+			}
+			if atTime.IsZero() {
+				atTime = time.Now()
+			}
+			state, err = nodesmgr.GetMembershipAt(strings.ToUpper(uuid), atTime)
+			if err != nil {
+				return fmt.Errorf("error getting membership - %s", err)
+			}
+		} else if apiFlag {
+			state, err = osctrlAPI.GetNodeMembership(env, uuid, at)
+			if err != nil {
+				return fmt.Errorf("error getting membership - %s", err)
+			}
+		}
+		if formatFlag == jsonFormat {
+			jsonRaw, err := json.Marshal(state)
+			if err != nil {
+				return fmt.Errorf("error marshaling - %s", err)
+			}
+			fmt.Println(string(jsonRaw))
+			return nil
+		}
+		fmt.Printf("Node %s at %s\n", state.UUID, state.At.Format(time.RFC3339))
+		fmt.Printf("Environments: %s\n", strings.Join(state.Environments, ", "))
+		fmt.Printf("Tags: %s\n", strings.Join(state.Tags, ", "))
+		return nil
+	}
+	var changes []nodes.NodeMembership
+	if dbFlag {
+		now := time.Now()
+		fromTime, err := environments.ParseSince(from, now)
+		if err != nil {
+			return err
+		}
+		toTime, err := environments.ParseSince(to, now)
+		if err != nil {
+			return err
+		}
+		if toTime.IsZero() {
+			toTime = now
+		}
+		changes, err = nodesmgr.GetMembershipChanges(strings.ToUpper(uuid), fromTime, toTime)
+		if err != nil {
+			return fmt.Errorf("error getting membership changes - %s", err)
+		}
+	} else if apiFlag {
+		changes, err = osctrlAPI.GetNodeMembershipChanges(env, uuid, from, to)
+		if err != nil {
+			return fmt.Errorf("error getting membership changes - %s", err)
+		}
+	}
+	header := []string{
+		"Timestamp",
+		"Entity",
+		"Name",
+		"Action",
+		"Actor",
+	}
+	// Prepare output
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(changes)
+		if err != nil {
+			return fmt.Errorf("error marshaling - %s", err)
+		}
+		fmt.Println(string(jsonRaw))
+	} else if formatFlag == csvFormat {
+		data := membershipToData(changes, header)
+		w := csv.NewWriter(os.Stdout)
+		if err := w.WriteAll(data); err != nil {
+			return fmt.Errorf("error writting csv - %s", err)
+		}
+	} else if formatFlag == prettyFormat {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader(header)
+		data := membershipToData(changes, nil)
+		table.AppendBulk(data)
+		table.Render()
+	}
+	return nil
+}
+
+func decommissionNode(c *cli.Context) error {
+	// Get values from flags
+	uuid := c.String("uuid")
+	if uuid == "" {
+		fmt.Println("❌ uuid is required")
+		os.Exit(1)
+	}
+	env := c.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	if dbFlag {
+		e, err := envs.Get(env)
+		if err != nil {
+			return fmt.Errorf("error env get - %s", err)
+		}
+		if _, err := nodesmgr.GetByUUIDEnv(uuid, e.ID); err != nil {
+			return fmt.Errorf("error getting node - %s", err)
+		}
+		if _, err := nodesmgr.Decommission(uuid, appName); err != nil {
+			return fmt.Errorf("error decommissioning - %s", err)
+		}
+	} else if apiFlag {
+		if _, err := osctrlAPI.DecommissionNode(env, uuid); err != nil {
+			return fmt.Errorf("error decommissioning node - %s", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Println("✅ node is being decommissioned, it will be archived once it stops contacting")
+	}
+	return nil
+}
+
+func pauseNode(c *cli.Context) error {
+	// Get values from flags
+	uuid := c.String("uuid")
+	if uuid == "" {
+		fmt.Println("❌ uuid is required")
+		os.Exit(1)
+	}
+	env := c.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	reason := c.String("reason")
+	var p nodes.NodePause
+	if dbFlag {
+		e, err := envs.Get(env)
+		if err != nil {
+			return fmt.Errorf("error env get - %s", err)
+		}
+		node, err := nodesmgr.GetByUUIDEnv(uuid, e.ID)
+		if err != nil {
+			return fmt.Errorf("error getting node - %s", err)
+		}
+		expires, err := users.ParseNoticeExpiry(c.String("expires"), time.Now())
+		if err != nil {
+			return fmt.Errorf("error parsing expiration - %s", err)
+		}
+		if p, err = nodesmgr.Pause(node.UUID, reason, appName, expires); err != nil {
+			return fmt.Errorf("error pausing - %s", err)
+		}
+		if err := envs.RecordActivity(e.ID, environments.ActivityPauses, appName, nodes.PauseSummary(p), "", ""); err != nil {
+			return fmt.Errorf("error recording activity - %s", err)
+		}
+	} else if apiFlag {
+		if p, err = osctrlAPI.PauseNode(env, uuid, reason, c.String("expires")); err != nil {
+			return fmt.Errorf("error pausing node - %s", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ node is paused, it keeps sending logs but does not get queries nor carves")
+		if !p.ExpiresAt.IsZero() {
+			fmt.Printf(" until %s", p.ExpiresAt.Format(time.RFC1123))
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+func unpauseNode(c *cli.Context) error {
+	// Get values from flags
+	uuid := c.String("uuid")
+	if uuid == "" {
+		fmt.Println("❌ uuid is required")
+		os.Exit(1)
+	}
+	env := c.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	if dbFlag {
+		e, err := envs.Get(env)
+		if err != nil {
+			return fmt.Errorf("error env get - %s", err)
+		}
+		node, err := nodesmgr.GetByUUIDEnv(uuid, e.ID)
+		if err != nil {
+			return fmt.Errorf("error getting node - %s", err)
+		}
+		p, err := nodesmgr.Unpause(node.UUID, appName)
+		if err != nil {
+			return fmt.Errorf("error unpausing - %s", err)
+		}
+		if err := envs.RecordActivity(e.ID, environments.ActivityPauses, appName, nodes.PauseSummary(p), "", ""); err != nil {
+			return fmt.Errorf("error recording activity - %s", err)
+		}
+	} else if apiFlag {
+		if _, err := osctrlAPI.UnpauseNode(env, uuid); err != nil {
+			return fmt.Errorf("error unpausing node - %s", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Println("✅ node is unpaused, the queries still active are served again")
+	}
+	return nil
+}
+
+func statusTokenNode(c *cli.Context) error {
+	// Get values from flags
+	uuid := c.String("uuid")
+	if uuid == "" {
+		fmt.Println("❌ uuid is required")
+		os.Exit(1)
+	}
+	env := c.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	// Status tokens are signed with the secret of the services, only the API can generate them
+	if dbFlag {
+		return fmt.Errorf("status tokens can only be generated with --api")
+	}
+	var s types.ApiStatusTokenResponse
+	if apiFlag {
+		s, err = osctrlAPI.StatusTokenNode(env, uuid, c.Int64("hours"))
+		if err != nil {
+			return fmt.Errorf("error generating status token - %s", err)
+		}
+	}
+	link := strings.TrimSuffix(c.String("url"), "/") + s.Path
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(s)
+		if err != nil {
+			return fmt.Errorf("error marshaling - %s", err)
+		}
+		fmt.Println(string(jsonRaw))
+	} else if !silentFlag {
+		fmt.Printf("✅ status link for node %s, expires %s:\n%s\n", uuid, s.ExpiresAt.Format(time.RFC1123), link)
+	}
+	return nil
+}
+
+// Helper function to convert decommissioned nodes into the data expected for output
+func decommissionsToData(ds []nodes.NodeDecommission, header []string) [][]string {
+	var data [][]string
+	if header != nil {
+		data = append(data, header)
+	}
+	for _, d := range ds {
+		_d := []string{
+			d.UUID,
+			d.Hostname,
+			d.Platform,
+			d.State,
+			d.Requester,
+			d.CreatedAt.String(),
+			d.LastContact.String(),
+			fmt.Sprintf("%d", d.Contacts),
+		}
+		data = append(data, _d)
+	}
+	return data
+}
+
+func listDecommissions(c *cli.Context) error {
+	// Get values from flags
+	env := c.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	state := c.String("state")
+	var ds []nodes.NodeDecommission
+	if dbFlag {
+		e, err := envs.Get(env)
+		if err != nil {
+			return fmt.Errorf("error env get - %s", err)
+		}
+		ds, err = nodesmgr.GetDecommissions(e.ID, state)
+		if err != nil {
+			return fmt.Errorf("error getting decommissioned nodes - %s", err)
+		}
+	} else if apiFlag {
+		ds, err = osctrlAPI.GetDecommissions(env, state)
+		if err != nil {
+			return fmt.Errorf("error getting decommissioned nodes - %s", err)
+		}
+	}
+	header := []string{
+		"UUID",
+		"Hostname",
+		"Platform",
+		"State",
+		"Requester",
+		"Decommissioned",
+		"Last Contact",
+		"Contacts",
+	}
+	// Prepare output
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(ds)
+		if err != nil {
+			return fmt.Errorf("error marshaling - %s", err)
+		}
+		fmt.Println(string(jsonRaw))
+	} else if formatFlag == csvFormat {
+		data := decommissionsToData(ds, header)
+		w := csv.NewWriter(os.Stdout)
+		if err := w.WriteAll(data); err != nil {
+			return fmt.Errorf("error writting csv - %s", err)
+		}
+	} else if formatFlag == prettyFormat {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader(header)
+		data := decommissionsToData(ds, nil)
+		table.AppendBulk(data)
+		table.Render()
+	}
+	return nil
+}
+
+func mergeNode(c *cli.Context) error {
+	// Get values from flags
+	into := c.String("into")
+	if into == "" {
+		fmt.Println("❌ into is required")
+		os.Exit(1)
+	}
+	from := c.String("from")
+	if from == "" {
+		fmt.Println("❌ from is required")
+		os.Exit(1)
+	}
+	env := c.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	var merge nodes.NodeMerge
+	if dbFlag {
+		e, err := envs.Get(env)
+		if err != nil {
+			return fmt.Errorf("error env get - %s", err)
+		}
+		for _, uuid := range []string{into, from} {
+			if _, err := nodesmgr.GetByUUIDEnv(uuid, e.ID); err != nil {
+				return fmt.Errorf("error getting node %s - %s", uuid, err)
+			}
+		}
+		th := nodes.NewMergeThresholds(settingsmgr.DuplicateScore(), settingsmgr.DuplicateHostnameDistance())
+		merge, err = nodesmgr.ManualMerge(into, from, appName, th)
+		if err != nil {
+			return fmt.Errorf("error merging nodes - %s", err)
+		}
+		if err := envs.RecordActivity(e.ID, environments.ActivityMerges, appName, nodes.MergeSummary(merge), "", merge.Evidence); err != nil {
+			return fmt.Errorf("error recording activity - %s", err)
+		}
+	} else if apiFlag {
+		merge, err = osctrlAPI.MergeNode(env, into, from)
+		if err != nil {
+			return fmt.Errorf("error merging nodes - %s", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ node %s merged into %s with score %d, merge %d can be split back\n", from, into, merge.Score, merge.ID)
+	}
+	return nil
+}
+
+// Helper function to convert merges of duplicate nodes into the data expected for output
+func mergesToData(ms []nodes.NodeMerge, header []string) [][]string {
+	var data [][]string
+	if header != nil {
+		data = append(data, header)
+	}
+	for _, m := range ms {
+		var evidence []string
+		for _, e := range m.Evidences() {
+			evidence = append(evidence, e.Detail)
+		}
+		_m := []string{
+			fmt.Sprintf("%d", m.ID),
+			m.State,
+			m.CanonicalUUID,
+			m.CanonicalHostname,
+			m.DuplicateUUID,
+			m.DuplicateHostname,
+			fmt.Sprintf("%d", m.Score),
+			strings.Join(evidence, "; "),
+			m.Proposer,
+			m.Reviewer,
+		}
+		data = append(data, _m)
+	}
+	return data
+}
+
+func listMerges(c *cli.Context) error {
+	// Get values from flags
+	env := c.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	state := c.String("state")
+	var ms []nodes.NodeMerge
+	if dbFlag {
+		e, err := envs.Get(env)
+		if err != nil {
+			return fmt.Errorf("error env get - %s", err)
+		}
+		ms, err = nodesmgr.GetMerges(e.ID, state)
+		if err != nil {
+			return fmt.Errorf("error getting node merges - %s", err)
+		}
+	} else if apiFlag {
+		ms, err = osctrlAPI.GetMerges(env, state)
+		if err != nil {
+			return fmt.Errorf("error getting node merges - %s", err)
+		}
+	}
+	header := []string{
+		"ID",
+		"State",
+		"Canonical",
+		"Canonical Hostname",
+		"Duplicate",
+		"Duplicate Hostname",
+		"Score",
+		"Evidence",
+		"Proposer",
+		"Reviewer",
+	}
+	// Prepare output
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(ms)
+		if err != nil {
+			return fmt.Errorf("error marshaling - %s", err)
+		}
+		fmt.Println(string(jsonRaw))
+	} else if formatFlag == csvFormat {
+		data := mergesToData(ms, header)
+		w := csv.NewWriter(os.Stdout)
+		if err := w.WriteAll(data); err != nil {
+			return fmt.Errorf("error writting csv - %s", err)
+		}
+	} else if formatFlag == prettyFormat {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader(header)
+		data := mergesToData(ms, nil)
+		table.AppendBulk(data)
+		table.Render()
+	}
+	return nil
+}
+
+func reviewMerge(c *cli.Context) error {
+	// Get values from flags
+	id := c.Uint("id")
+	if id == 0 {
+		fmt.Println("❌ id is required")
+		os.Exit(1)
+	}
+	action := c.String("action")
+	if action != "approve" && action != "reject" && action != "split" {
+		fmt.Println("❌ action must be approve, reject or split")
+		os.Exit(1)
+	}
+	env := c.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	var merge nodes.NodeMerge
+	if dbFlag {
+		e, err := envs.Get(env)
+		if err != nil {
+			return fmt.Errorf("error env get - %s", err)
+		}
+		if merge, err = nodesmgr.GetMerge(id); err != nil || merge.EnvironmentID != e.ID {
+			return fmt.Errorf("merge %d not found in %s", id, env)
+		}
+		switch action {
+		case "approve":
+			merge, err = nodesmgr.ApproveMerge(id, appName)
+		case "reject":
+			merge, err = nodesmgr.RejectMerge(id, appName)
+		case "split":
+			merge, err = nodesmgr.SplitMerge(id, appName, nodes.MergeWindow(settingsmgr.MergeSplitDays()))
+		}
+		if err != nil {
+			return fmt.Errorf("error reviewing merge - %s", err)
+		}
+		if err := envs.RecordActivity(e.ID, environments.ActivityMerges, appName, nodes.MergeSummary(merge), "", merge.Evidence); err != nil {
+			return fmt.Errorf("error recording activity - %s", err)
+		}
+	} else if apiFlag {
+		merge, err = osctrlAPI.ReviewMerge(env, id, action)
+		if err != nil {
+			return fmt.Errorf("error reviewing merge - %s", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ merge %d is %s\n", merge.ID, merge.State)
+	}
+	return nil
+}
+
+func findNodes(c *cli.Context) error {
+	// Get search term from arguments
+	term := strings.TrimSpace(c.Args().First())
+	if len(term) < nodes.MinSearchLength {
+		fmt.Printf("❌ search term of at least %d characters is required\n", nodes.MinSearchLength)
+		os.Exit(1)
+	}
+	var matches []nodes.NodeMatch
+	if dbFlag {
+		// Access to the DB means access to all environments
+		envAll, err := envs.All()
+		if err != nil {
+			return fmt.Errorf("error getting environments - %s", err)
+		}
+		var envIDs []uint
+		for _, e := range envAll {
+			envIDs = append(envIDs, e.ID)
+		}
+		matches, err = nodesmgr.Search(context.Background(), term, envIDs, nodes.DefaultSearchLimit)
+		if err != nil {
+			return fmt.Errorf("error searching nodes - %s", err)
+		}
+	} else if apiFlag {
+		matches, err = osctrlAPI.SearchNodes(term)
+		if err != nil {
+			return fmt.Errorf("error searching nodes - %s", err)
+		}
+	}
+	header := []string{
+		"UUID",
+		"Hostname",
+		"Environment",
+		"Platform",
+		"Matched",
+		"Last Seen",
+	}
+	data := [][]string{}
+	for _, m := range matches {
+		data = append(data, []string{
+			m.UUID,
+			m.Hostname,
+			m.Environment,
+			m.Platform,
+			m.Field + ": " + m.Value,
+			m.LastSeen.Format("2006-01-02 15:04:05"),
+		})
+	}
+	// Prepare output
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(matches)
+		if err != nil {
+			return fmt.Errorf("error marshaling - %s", err)
+		}
+		fmt.Println(string(jsonRaw))
+	} else if formatFlag == csvFormat {
+		w := csv.NewWriter(os.Stdout)
+		if err := w.WriteAll(append([][]string{header}, data...)); err != nil {
+			return fmt.Errorf("error writting csv - %s", err)
+		}
+	} else if formatFlag == prettyFormat {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader(header)
+		if len(matches) > 0 {
+			fmt.Printf("Nodes matching %s (%d):\n", term, len(matches))
+			table.AppendBulk(data)
+		} else {
+			fmt.Printf("No nodes matching %s\n", term)
+		}
+		table.Render()
+	}
+	return nil
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/users"
+	"github.com/jmpsec/osctrl/utils"
+	"github.com/olekukonko/tablewriter"
+	"github.com/urfave/cli/v2"
+)
+
+// Helper to show the state of a notice
+func noticeState(n users.Notice, now time.Time) string {
+	if n.DeletedAt.Valid {
+		return "removed by " + n.RemovedBy
+	}
+	if n.Expired(now) {
+		return "expired"
+	}
+	return "active"
+}
+
+// Helper to show the environments of a notice by name
+func noticeScope(n users.Notice, envNames map[string]string) string {
+	if n.Global() {
+		return "all"
+	}
+	names := []string{}
+	for _, e := range n.EnvironmentList() {
+		if name, ok := envNames[e]; ok {
+			names = append(names, name)
+		} else {
+			names = append(names, e)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// Helper to get the names of all environments by UUID
+func envNamesMap() (map[string]string, error) {
+	var all []environments.TLSEnvironment
+	var err error
+	if dbFlag {
+		all, err = envs.All()
+	} else if apiFlag {
+		all, err = osctrlAPI.GetEnvironments()
+	}
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]string)
+	for _, e := range all {
+		names[e.UUID] = e.Name
+	}
+	return names, nil
+}
+
+// Helper to prepare the request of a notice from flags, only the flags that are set change the base request
+func noticeRequest(c *cli.Context, base types.ApiNoticeRequest) types.ApiNoticeRequest {
+	if c.IsSet("message") {
+		base.Message = c.String("message")
+	}
+	if c.IsSet("severity") {
+		base.Severity = c.String("severity")
+	}
+	if c.IsSet("env") {
+		base.Environments = []string{}
+		for _, e := range strings.Split(c.String("env"), ",") {
+			if e = strings.TrimSpace(e); e != "" {
+				base.Environments = append(base.Environments, e)
+			}
+		}
+	}
+	if c.IsSet("expires") {
+		base.ExpiresAt = c.String("expires")
+	}
+	if c.IsSet("require-ack") {
+		base.RequireAck = c.Bool("require-ack")
+	}
+	if c.IsSet("confirm") {
+		base.ConfirmActions = c.Bool("confirm")
+	}
+	return base
+}
+
+// Helper to prepare the request of an existing notice, to update it
+func noticeToRequest(n users.Notice) types.ApiNoticeRequest {
+	nr := types.ApiNoticeRequest{
+		Message:        n.Message,
+		Severity:       n.Severity,
+		Environments:   n.EnvironmentList(),
+		RequireAck:     n.RequireAck,
+		ConfirmActions: n.ConfirmActions,
+	}
+	if !n.ExpiresAt.IsZero() {
+		nr.ExpiresAt = n.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+	return nr
+}
+
+// Helper to prepare a notice from a request, environments by name or UUID
+func requestToNotice(nr types.ApiNoticeRequest) (users.Notice, error) {
+	n := users.Notice{
+		Message:        nr.Message,
+		Severity:       nr.Severity,
+		RequireAck:     nr.RequireAck,
+		ConfirmActions: nr.ConfirmActions,
+		Creator:        appName,
+	}
+	expires, err := users.ParseNoticeExpiry(nr.ExpiresAt, time.Now())
+	if err != nil {
+		return n, err
+	}
+	n.ExpiresAt = expires
+	var envUUIDs []string
+	for _, e := range nr.Environments {
+		env, err := envs.Get(e)
+		if err != nil {
+			return n, fmt.Errorf("error env get %s - %s", e, err)
+		}
+		envUUIDs = append(envUUIDs, env.UUID)
+	}
+	n.Environments = strings.Join(envUUIDs, ",")
+	return n, nil
+}
+
+func listNotices(c *cli.Context) error {
+	// Get values from flags
+	envName := c.String("env")
+	history := c.Bool("history")
+	var notices []users.Notice
+	var err error
+	if dbFlag {
+		if history {
+			notices, err = adminUsers.NoticesHistory()
+		} else {
+			notices, err = adminUsers.ActiveNotices(time.Now())
+		}
+		if err != nil {
+			return fmt.Errorf("error getting notices - %s", err)
+		}
+		if envName != "" {
+			env, err := envs.Get(envName)
+			if err != nil {
+				return fmt.Errorf("error env get - %s", err)
+			}
+			inScope := []users.Notice{}
+			for _, n := range notices {
+				if n.InScope(env.UUID) {
+					inScope = append(inScope, n)
+				}
+			}
+			notices = inScope
+		}
+	} else if apiFlag {
+		notices, err = osctrlAPI.GetNotices(envName, history)
+		if err != nil {
+			return fmt.Errorf("error getting notices - %s", err)
+		}
+	}
+	envNames, err := envNamesMap()
+	if err != nil {
+		return fmt.Errorf("error getting environments - %s", err)
+	}
+	header := []string{
+		"ID",
+		"Severity",
+		"Message",
+		"Scope",
+		"Expires",
+		"Require Ack",
+		"Confirm",
+		"State",
+		"Creator",
+		"Created",
+	}
+	now := time.Now()
+	data := [][]string{}
+	for _, n := range notices {
+		expires := "never"
+		if !n.ExpiresAt.IsZero() {
+			expires = utils.PastFutureTimes(n.ExpiresAt)
+		}
+		data = append(data, []string{
+			strconv.FormatUint(uint64(n.ID), 10),
+			n.Severity,
+			n.Message,
+			noticeScope(n, envNames),
+			expires,
+			stringifyBool(n.RequireAck),
+			stringifyBool(n.ConfirmActions),
+			noticeState(n, now),
+			n.Creator,
+			utils.PastFutureTimes(n.CreatedAt),
+		})
+	}
+	// Prepare output
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(notices)
+		if err != nil {
+			return fmt.Errorf("error marshaling - %s", err)
+		}
+		fmt.Println(string(jsonRaw))
+	} else if formatFlag == csvFormat {
+		w := csv.NewWriter(os.Stdout)
+		if err := w.WriteAll(append([][]string{header}, data...)); err != nil {
+			return fmt.Errorf("error writting csv - %s", err)
+		}
+	} else if formatFlag == prettyFormat {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader(header)
+		if len(notices) > 0 {
+			fmt.Printf("Existing notices (%d):\n", len(notices))
+			table.AppendBulk(data)
+		} else {
+			fmt.Println("No notices")
+		}
+		table.Render()
+	}
+	return nil
+}
+
+func addNotice(c *cli.Context) error {
+	// Get values from flags
+	nr := noticeRequest(c, types.ApiNoticeRequest{})
+	if strings.TrimSpace(nr.Message) == "" {
+		fmt.Println("❌ message is required")
+		os.Exit(1)
+	}
+	var n users.Notice
+	var err error
+	if dbFlag {
+		notice, err := requestToNotice(nr)
+		if err != nil {
+			return fmt.Errorf("error create notice - %s", err)
+		}
+		n, err = adminUsers.NewNotice(notice)
+		if err != nil {
+			return fmt.Errorf("error create notice - %s", err)
+		}
+	} else if apiFlag {
+		n, err = osctrlAPI.SaveNotice(0, nr)
+		if err != nil {
+			return fmt.Errorf("error create notice - %s", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ notice %d created successfully\n", n.ID)
+	}
+	return nil
+}
+
+func updateNotice(c *cli.Context) error {
+	// Get values from flags
+	id := c.Uint("id")
+	if id == 0 {
+		fmt.Println("❌ notice ID is required")
+		os.Exit(1)
+	}
+	if dbFlag {
+		existing, err := adminUsers.GetNotice(id)
+		if err != nil {
+			return fmt.Errorf("error get notice - %s", err)
+		}
+		notice, err := requestToNotice(noticeRequest(c, noticeToRequest(existing)))
+		if err != nil {
+			return fmt.Errorf("error update notice - %s", err)
+		}
+		if _, err := adminUsers.UpdateNotice(id, notice); err != nil {
+			return fmt.Errorf("error update notice - %s", err)
+		}
+	} else if apiFlag {
+		notices, err := osctrlAPI.GetNotices("", false)
+		if err != nil {
+			return fmt.Errorf("error getting notices - %s", err)
+		}
+		var existing *users.Notice
+		for i := range notices {
+			if notices[i].ID == id {
+				existing = &notices[i]
+			}
+		}
+		if existing == nil {
+			return fmt.Errorf("notice %d not found", id)
+		}
+		if _, err := osctrlAPI.SaveNotice(id, noticeRequest(c, noticeToRequest(*existing))); err != nil {
+			return fmt.Errorf("error update notice - %s", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ notice %d updated successfully\n", id)
+	}
+	return nil
+}
+
+func deleteNotice(c *cli.Context) error {
+	// Get values from flags
+	id := c.Uint("id")
+	if id == 0 {
+		fmt.Println("❌ notice ID is required")
+		os.Exit(1)
+	}
+	if dbFlag {
+		if err := adminUsers.DeleteNotice(id, appName); err != nil {
+			return fmt.Errorf("error delete notice - %s", err)
+		}
+	} else if apiFlag {
+		if err := osctrlAPI.DeleteNotice(id); err != nil {
+			return fmt.Errorf("error delete notice - %s", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ notice %d removed successfully\n", id)
+	}
+	return nil
+}
@@ -4,8 +4,11 @@ import (
 	"encoding/csv"
 	"encoding/json"
 	"fmt"
+	"log"
 	"os"
+	"strings"
 
+	"github.com/jmpsec/osctrl/environments"
 	"github.com/jmpsec/osctrl/users"
 	"github.com/olekukonko/tablewriter"
 	"github.com/urfave/cli/v2"
@@ -68,6 +71,10 @@ func changePermissions(c *cli.Context) error {
 		if err != nil {
 			return fmt.Errorf("error getting environment - %s", err)
 		}
+		before, err := adminUsers.GetEnvAccess(username, env.UUID)
+		if err != nil {
+			return fmt.Errorf("error getting access - %s", err)
+		}
 		// If admin, then all permissions follow
 		if admin {
 			user = true
@@ -85,16 +92,24 @@ func changePermissions(c *cli.Context) error {
 				return fmt.Errorf("error setting admin - %s", err)
 			}
 		}
-		if carve {
+		// Carve and query permissions can be revoked on their own, with --carve=false or --query=false
+		if carve || c.IsSet("carve") {
 			if err := adminUsers.SetEnvCarve(username, env.UUID, carve); err != nil {
 				return fmt.Errorf("error setting carve - %s", err)
 			}
 		}
-		if query {
+		if query || c.IsSet("query") {
 			if err := adminUsers.SetEnvQuery(username, env.UUID, query); err != nil {
 				return fmt.Errorf("error setting query - %s", err)
 			}
 		}
+		after, err := adminUsers.GetEnvAccess(username, env.UUID)
+		if err != nil {
+			return fmt.Errorf("error getting access - %s", err)
+		}
+		if err := users.RecordAccessChanges(envs, username, appName, users.UserAccess{env.UUID: before}, users.UserAccess{env.UUID: after}); err != nil {
+			log.Printf("error recording permissions activity - %s", err)
+		}
 	} else if apiFlag {
 	}
 	if !silentFlag {
@@ -217,6 +232,10 @@ func allPermissions(c *cli.Context) error {
 			return fmt.Errorf("error getting access - %s", err)
 		}
 	} else if apiFlag {
+		existingAccess, err = osctrlAPI.GetUserPermissions(username)
+		if err != nil {
+			return fmt.Errorf("error getting access - %s", err)
+		}
 	}
 	header := []string{
 		"Environment",
@@ -247,3 +266,145 @@ func allPermissions(c *cli.Context) error {
 	}
 	return nil
 }
+
+// Actions to change permissions in bulk
+const (
+	bulkSet    = "set"
+	bulkAdd    = "add"
+	bulkRemove = "remove"
+)
+
+// Helper to get all environments by name and UUID, from the DB or the API
+func getEnvironmentsMap() (environments.MapEnvironments, error) {
+	if dbFlag {
+		return envs.GetMap()
+	}
+	all, err := osctrlAPI.GetEnvironments()
+	if err != nil {
+		return nil, err
+	}
+	envsMap := make(environments.MapEnvironments)
+	for _, e := range all {
+		envsMap[e.Name] = e
+		envsMap[e.UUID] = e
+	}
+	return envsMap, nil
+}
+
+// Helper to get all the access of a user, from the DB or the API
+func getUserAccess(username string) (users.UserAccess, error) {
+	if dbFlag {
+		return adminUsers.GetAccess(username)
+	}
+	return osctrlAPI.GetUserPermissions(username)
+}
+
+// Helper to format user permissions to display, with environment names when they are known
+func stringifyAccessNames(envsMap environments.MapEnvironments, access users.UserAccess) string {
+	res := ""
+	for e, p := range access {
+		if env, ok := envsMap[e]; ok {
+			e = env.Name
+		}
+		res += fmt.Sprintf("%s [%s]\n", e, stringifyEnvAccess(p))
+	}
+	return res
+}
+
+// Helper to split a comma separated list of environments
+func splitEnvs(list string) []string {
+	var res []string
+	for _, e := range strings.Split(list, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+func setPermissions(c *cli.Context) error {
+	return bulkPermissions(c, bulkSet)
+}
+
+func addPermissions(c *cli.Context) error {
+	return bulkPermissions(c, bulkAdd)
+}
+
+func removePermissions(c *cli.Context) error {
+	return bulkPermissions(c, bulkRemove)
+}
+
+// Helper to set, add or remove permissions for a user in multiple environments at once
+func bulkPermissions(c *cli.Context, action string) error {
+	// Get values from flags
+	username := c.String("username")
+	if username == "" {
+		fmt.Println("❌ username is required")
+		os.Exit(1)
+	}
+	envList := splitEnvs(c.String("envs"))
+	fromUser := c.String("from-user")
+	if len(envList) == 0 && (fromUser == "" || action == bulkRemove) {
+		fmt.Println("❌ envs is required")
+		os.Exit(1)
+	}
+	// All environments must exist before anything is changed
+	envsMap, err := getEnvironmentsMap()
+	if err != nil {
+		return fmt.Errorf("error getting environments - %s", err)
+	}
+	uuids, err := users.ResolveEnvs(envsMap, envList)
+	if err != nil {
+		return err
+	}
+	current, err := getUserAccess(username)
+	if err != nil {
+		return fmt.Errorf("error getting access - %s", err)
+	}
+	// Prepare the complete set of permissions
+	access := make(users.UserAccess)
+	switch action {
+	case bulkRemove:
+		access = users.RemoveAccess(current, uuids)
+	default:
+		if fromUser != "" {
+			if access, err = getUserAccess(fromUser); err != nil {
+				return fmt.Errorf("error getting access for %s - %s", fromUser, err)
+			}
+		}
+		if len(uuids) > 0 {
+			levelAccess, err := users.LevelAccess(c.String("level"))
+			if err != nil {
+				return err
+			}
+			extra := make(users.UserAccess)
+			for _, u := range uuids {
+				extra[u] = levelAccess
+			}
+			access = users.MergeAccess(access, extra)
+		}
+		if action == bulkAdd {
+			access = users.MergeAccess(current, access)
+		}
+	}
+	var change users.AccessChange
+	if dbFlag {
+		change.Before, change.After, err = adminUsers.SetAccess(username, appName, access)
+		if err == nil {
+			if err := users.RecordAccessChanges(envs, username, appName, change.Before, change.After); err != nil {
+				log.Printf("error recording permissions activity - %s", err)
+			}
+		}
+	} else if apiFlag {
+		change, err = osctrlAPI.SetUserPermissions(username, access)
+	}
+	if err != nil {
+		return fmt.Errorf("error setting permissions - %s", err)
+	}
+	if !silentFlag {
+		fmt.Printf("✅ permissions for user %s changed successfully\n", username)
+		fmt.Printf("before:\n%s", stringifyAccessNames(envsMap, change.Before))
+		fmt.Printf("after:\n%s", stringifyAccessNames(envsMap, change.After))
+	}
+	return nil
+}
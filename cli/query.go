@@ -1,13 +1,24 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
 	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/jmpsec/osctrl/backend"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/logging"
+	"github.com/jmpsec/osctrl/nodes"
 	"github.com/jmpsec/osctrl/queries"
+	"github.com/jmpsec/osctrl/types"
 	"github.com/olekukonko/tablewriter"
 	"github.com/urfave/cli/v2"
 )
@@ -40,6 +51,8 @@ func queryToData(q queries.DistributedQuery, header []string) [][]string {
 		stringifyBool(q.Hidden),
 		stringifyBool(q.Completed),
 		stringifyBool(q.Deleted),
+		q.TicketID,
+		strings.Join(q.LabelList(), " "),
 	}
 	data = append(data, _q)
 	return data
@@ -79,8 +92,12 @@ func listQueries(c *cli.Context) error {
 		if err != nil {
 			return fmt.Errorf("error get queries - %s", err)
 		}
+		qs, err = queriesmgr.FilterContext(context.Background(), qs, queries.ParseContextFilter(c.String("label"), c.String("ticket")))
+		if err != nil {
+			return fmt.Errorf("error filter queries - %s", err)
+		}
 	} else if apiFlag {
-		qs, err = osctrlAPI.GetQueries(env)
+		qs, err = osctrlAPI.GetQueries(env, c.String("label"), c.String("ticket"))
 		if err != nil {
 			return fmt.Errorf("error get queries - %s", err)
 		}
@@ -96,6 +113,8 @@ func listQueries(c *cli.Context) error {
 		"Hidden",
 		"Completed",
 		"Deleted",
+		"Ticket",
+		"Labels",
 	}
 	// Prepare output
 	if formatFlag == jsonFormat {
@@ -173,16 +192,25 @@ func deleteQuery(c *cli.Context) error {
 		if err != nil {
 			return err
 		}
-		if err := queriesmgr.Delete(name, e.ID); err != nil {
+		if c.Bool("hard") {
+			err = queriesmgr.HardDelete(name, e.ID, backend.TrashQueries, appName)
+		} else {
+			err = queriesmgr.Delete(name, e.ID, appName)
+		}
+		if err != nil {
 			return fmt.Errorf("error %s", err)
 		}
 	} else if apiFlag {
-		if err := osctrlAPI.DeleteQuery(env, name); err != nil {
+		if err := osctrlAPI.DeleteQuery(env, name, c.Bool("hard")); err != nil {
 			return fmt.Errorf("error %s", err)
 		}
 	}
 	if !silentFlag {
-		fmt.Printf("✅ query %s deleted successfully", name)
+		if c.Bool("hard") {
+			fmt.Printf("✅ query %s deleted permanently", name)
+		} else {
+			fmt.Printf("✅ query %s moved to the trash", name)
+		}
 	}
 	return nil
 }
@@ -190,8 +218,18 @@ func deleteQuery(c *cli.Context) error {
 func runQuery(c *cli.Context) error {
 	// Get values from flags
 	query := c.String("query")
-	if query == "" {
-		fmt.Println("❌ query is required")
+	template := c.String("template")
+	if query == "" && template == "" {
+		fmt.Println("❌ query or template is required")
+		os.Exit(1)
+	}
+	if query != "" && template != "" {
+		fmt.Println("❌ query and template can not be used together")
+		os.Exit(1)
+	}
+	values, err := parseTemplateValues(c.StringSlice("var"))
+	if err != nil {
+		fmt.Printf("❌ %s\n", err)
 		os.Exit(1)
 	}
 	env := c.String("env")
@@ -199,40 +237,152 @@ func runQuery(c *cli.Context) error {
 		fmt.Println("❌ environment is required")
 		os.Exit(1)
 	}
+	window, err := windowFlags(c)
+	if err != nil {
+		fmt.Printf("❌ %s\n", err)
+		os.Exit(1)
+	}
+	attrFilters, err := queries.ParseAttributeTargets(c.StringSlice("attribute"))
+	if err != nil {
+		fmt.Printf("❌ %s\n", err)
+		os.Exit(1)
+	}
 	uuid := c.String("uuid")
-	if uuid == "" {
-		fmt.Println("❌ UUID is required")
+	if uuid == "" && window.Empty() && len(attrFilters) == 0 {
+		fmt.Println("❌ UUID, time window or attributes are required")
 		os.Exit(1)
 	}
 	hidden := c.Bool("hidden")
+	profile := c.Bool("profile")
+	sample := c.Int("sample")
+	qc, err := queryContextFlags(c)
+	if err != nil {
+		fmt.Printf("❌ %s\n", err)
+		os.Exit(1)
+	}
+	group := c.String("group")
+	if err := queries.ValidateGroup(group); err != nil {
+		fmt.Printf("❌ %s\n", err)
+		os.Exit(1)
+	}
+	priority := c.Int("priority")
+	override := c.Bool("override")
+	justification := c.String("justification")
+	if override && strings.TrimSpace(justification) == "" {
+		fmt.Println("❌ justification is required to override query policies")
+		os.Exit(1)
+	}
+	visibility := c.String("visibility")
+	team := c.String("team")
+	if err := queries.ValidateVisibility(visibility, team); err != nil {
+		fmt.Printf("❌ %s\n", err)
+		os.Exit(1)
+	}
 	if dbFlag {
 		e, err := envs.Get(env)
 		if err != nil {
 			return fmt.Errorf("error env get - %s", err)
 		}
+		if err := adminUsers.CheckConfirmation(e.UUID, c.Bool("confirm-notices")); err != nil {
+			return err
+		}
+		if err := checkLaunch(e, uuid, !window.Empty() || len(attrFilters) > 0, c.String("confirm-name")); err != nil {
+			return err
+		}
+		policy, err := envs.CategoryPolicy(e.Category)
+		if err != nil {
+			return err
+		}
+		expiration := queries.NewExpirationRange(int64(policy.Expiration(e)), settingsmgr.QueryExpirationMinHours(), settingsmgr.QueryExpirationMaxHours())
+		expiresAt, err := expiration.ExpiresAt(c.String("expiration"), time.Now())
+		if err != nil {
+			return fmt.Errorf("error expiration - %s", err)
+		}
+		queryType := queries.StandardQueryType
+		if profile {
+			queryType = queries.ProfileQueryType
+			if sample <= 0 {
+				sample = queries.DefaultProfileSample
+			}
+		}
 		queryName := queries.GenQueryName()
 		newQuery := queries.DistributedQuery{
-			Query:         query,
-			Name:          queryName,
-			Creator:       appName,
-			Expected:      0,
-			Executions:    0,
-			Active:        true,
-			Completed:     false,
-			Deleted:       false,
-			Hidden:        hidden,
-			Type:          queries.StandardQueryType,
-			EnvironmentID: e.ID,
+			Query:          query,
+			Name:           queryName,
+			Creator:        appName,
+			Expected:       0,
+			Executions:     0,
+			Active:         true,
+			Completed:      false,
+			Deleted:        false,
+			Hidden:         hidden,
+			Type:           queryType,
+			SampleSize:     sample,
+			EnvironmentID:  e.ID,
+			Group:          group,
+			Priority:       priority,
+			ExpiresAt:      expiresAt,
+			Visibility:     visibility,
+			VisibilityTeam: team,
+		}
+		var tpl *queries.QueryTemplate
+		if template != "" {
+			t, err := queriesmgr.GetTemplate(template)
+			if err != nil {
+				return fmt.Errorf("error get template - %s", err)
+			}
+			if n, err := nodesmgr.GetByUUID(uuid); err == nil && !t.SupportsPlatform(n.Platform) {
+				return fmt.Errorf("template %s does not support platform %s", t.Name, n.Platform)
+			}
+			if err := queries.ApplyTemplate(&newQuery, t, values); err != nil {
+				return fmt.Errorf("error template variables - %s", err)
+			}
+			tpl = &t
+		}
+		// Query policy of the CLI, it can be overridden with a justification
+		overridden := queriesmgr.CheckPolicy(e.ID, appName, newQuery.Query, tpl)
+		if overridden != nil && !override {
+			return overridden
 		}
 		if err := queriesmgr.Create(newQuery); err != nil {
 			return fmt.Errorf("error query create - %s", err)
 		}
+		if overridden != nil {
+			msg := fmt.Sprintf("query %s run overriding policy (%v): %s", queryName, overridden, justification)
+			if err := envs.RecordActivity(e.ID, environments.ActivityPolicies, appName, msg, "", newQuery.Query); err != nil {
+				return fmt.Errorf("error record activity - %s", err)
+			}
+		}
+		if !qc.Empty() {
+			if _, err := queriesmgr.SetContext(queryName, e.ID, qc); err != nil {
+				return fmt.Errorf("error set context - %s", err)
+			}
+		}
 		if (uuid != "") && nodesmgr.CheckByUUID(uuid) {
 			if err := queriesmgr.CreateTarget(queryName, queries.QueryTargetUUID, uuid); err != nil {
 				return fmt.Errorf("error create target - %s", err)
 			}
 		}
-		if err := queriesmgr.SetExpected(queryName, 1, e.ID); err != nil {
+		attrTargets, err := queriesmgr.CreateAttributeTargets(queryName, attrFilters)
+		if err != nil {
+			return fmt.Errorf("error create attribute targets - %s", err)
+		}
+		if err := queriesmgr.CreateWindowTargets(queryName, window); err != nil {
+			return fmt.Errorf("error create window targets - %s", err)
+		}
+		targeted, err := queriesmgr.Materialize(queryName, e.ID)
+		if err != nil {
+			return fmt.Errorf("error materialize - %s", err)
+		}
+		expected := 1
+		if !window.Empty() || attrTargets > 0 {
+			expected = targeted
+		}
+		if profile {
+			if err := queriesmgr.SetProfileTargets(queryName, expected, e.ID); err != nil {
+				return fmt.Errorf("error set profile targets - %s", err)
+			}
+		} else if err := queriesmgr.SetExpected(queryName, expected, e.ID); err != nil {
 			return fmt.Errorf("error set expected - %s", err)
 		}
 		if !silentFlag {
@@ -240,7 +390,33 @@ func runQuery(c *cli.Context) error {
 		}
 		return nil
 	} else if apiFlag {
-		q, err := osctrlAPI.RunQuery(env, uuid, query, hidden)
+		q, err := osctrlAPI.RunQuery(env, types.ApiDistributedQueryRequest{
+			UUID:           uuid,
+			Query:          query,
+			Hidden:         hidden,
+			Profile:        profile,
+			Sample:         sample,
+			Template:       template,
+			Variables:      values,
+			Description:    qc.Description,
+			TicketID:       qc.TicketID,
+			TicketURL:      qc.TicketURL,
+			Labels:         qc.Labels,
+			EnrolledAfter:  c.String("enrolled-after"),
+			EnrolledBefore: c.String("enrolled-before"),
+			SeenAfter:      c.String("seen-after"),
+			SeenBefore:     c.String("seen-before"),
+			Attributes:     c.StringSlice("attribute"),
+			ConfirmNotices: c.Bool("confirm-notices"),
+			ConfirmName:    c.String("confirm-name"),
+			Group:          group,
+			Priority:       priority,
+			Override:       override,
+			Justification:  justification,
+			Expiration:     c.String("expiration"),
+			Visibility:     visibility,
+			VisibilityTeam: team,
+		})
 		if err != nil {
 			return fmt.Errorf("error run query - %s", err)
 		}
@@ -250,3 +426,848 @@ func runQuery(c *cli.Context) error {
 	}
 	return nil
 }
+
+func listQueryGroups(c *cli.Context) error {
+	// Get values from flags
+	env := c.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	// Retrieve data
+	var groups []queries.GroupUtilization
+	if dbFlag {
+		e, err := envs.Get(env)
+		if err != nil {
+			return fmt.Errorf("error env get - %s", err)
+		}
+		groups, err = queriesmgr.GroupsUtilization(context.Background(), e.ID)
+		if err != nil {
+			return fmt.Errorf("error get groups - %s", err)
+		}
+	} else if apiFlag {
+		var err error
+		groups, err = osctrlAPI.GetQueryGroups(env)
+		if err != nil {
+			return fmt.Errorf("error get groups - %s", err)
+		}
+	}
+	header := []string{
+		"Group",
+		"Queries",
+		"Per node",
+		"Total",
+		"In flight",
+		"Waiting",
+	}
+	var data [][]string
+	for _, g := range groups {
+		data = append(data, []string{
+			g.Name,
+			strings.Join(g.Queries, ", "),
+			strconv.Itoa(g.MaxPerNode),
+			strconv.Itoa(g.MaxTotal),
+			strconv.FormatInt(g.InFlight, 10),
+			strconv.FormatInt(g.Waiting, 10),
+		})
+	}
+	// Prepare output
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(groups)
+		if err != nil {
+			return fmt.Errorf("error json marshal - %s", err)
+		}
+		fmt.Println(string(jsonRaw))
+	} else if formatFlag == csvFormat {
+		w := csv.NewWriter(os.Stdout)
+		if err := w.WriteAll(append([][]string{header}, data...)); err != nil {
+			return fmt.Errorf("error csv writeall - %s", err)
+		}
+	} else if formatFlag == prettyFormat {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader(header)
+		if len(groups) > 0 {
+			fmt.Printf("Existing query groups (%d):\n", len(groups))
+			table.AppendBulk(data)
+		} else {
+			fmt.Println("No query groups")
+		}
+		table.Render()
+	}
+	return nil
+}
+
+func showQueryArchive(c *cli.Context) error {
+	// Get values from flags
+	name := c.String("name")
+	if name == "" {
+		fmt.Println("❌ query name is required")
+		os.Exit(1)
+	}
+	env := c.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	// Retrieve data
+	var a queries.QueryArchive
+	if dbFlag {
+		e, err := envs.Get(env)
+		if err != nil {
+			return fmt.Errorf("error env get - %s", err)
+		}
+		a, err = queriesmgr.GetArchive(name, e.ID)
+		if err != nil {
+			return fmt.Errorf("error get archive - %s", err)
+		}
+	} else if apiFlag {
+		var err error
+		a, err = osctrlAPI.GetQueryArchive(env, name)
+		if err != nil {
+			return fmt.Errorf("error get archive - %s", err)
+		}
+	}
+	header := []string{
+		"Query",
+		"State",
+		"Storage",
+		"Results",
+		"Executions",
+		"Size",
+		"Progress",
+		"SHA256",
+		"Evicted",
+	}
+	evicted := ""
+	if a.State == queries.ArchiveRehydrated {
+		evicted = a.EvictAt.Format(time.RFC3339)
+	}
+	data := [][]string{{
+		a.Name,
+		a.State,
+		a.Storage,
+		strconv.Itoa(a.Rows),
+		strconv.Itoa(a.Executions),
+		strconv.FormatInt(a.Size, 10),
+		strconv.Itoa(a.Progress()) + "%",
+		a.SHA256,
+		evicted,
+	}}
+	// Prepare output
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(a)
+		if err != nil {
+			return fmt.Errorf("error json marshal - %s", err)
+		}
+		fmt.Println(string(jsonRaw))
+	} else if formatFlag == csvFormat {
+		w := csv.NewWriter(os.Stdout)
+		if err := w.WriteAll(append([][]string{header}, data...)); err != nil {
+			return fmt.Errorf("error csv writeall - %s", err)
+		}
+	} else if formatFlag == prettyFormat {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader(header)
+		table.AppendBulk(data)
+		table.Render()
+		if a.Error != "" {
+			fmt.Printf("Last rehydration failed: %s\n", a.Error)
+		}
+	}
+	return nil
+}
+
+func rehydrateQuery(c *cli.Context) error {
+	// Get values from flags
+	name := c.String("name")
+	if name == "" {
+		fmt.Println("❌ query name is required")
+		os.Exit(1)
+	}
+	env := c.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	if dbFlag {
+		e, err := envs.Get(env)
+		if err != nil {
+			return fmt.Errorf("error env get - %s", err)
+		}
+		a, err := queriesmgr.RequestRehydrate(name, e.ID, appName)
+		if err != nil {
+			return fmt.Errorf("error rehydrate - %s", err)
+		}
+		if err := envs.RecordActivity(e.ID, environments.ActivityArchives, appName, queries.ArchiveSummary(a), "", ""); err != nil {
+			return fmt.Errorf("error recording activity - %s", err)
+		}
+	} else if apiFlag {
+		if _, err := osctrlAPI.RehydrateQuery(env, name); err != nil {
+			return fmt.Errorf("error rehydrate - %s", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ rehydration of query %s requested successfully\n", name)
+	}
+	return nil
+}
+
+func setQueryGroupLimits(c *cli.Context) error {
+	// Get values from flags
+	name := c.String("name")
+	if name == "" {
+		fmt.Println("❌ group name is required")
+		os.Exit(1)
+	}
+	env := c.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	perNode := c.Int("per-node")
+	total := c.Int("total")
+	if dbFlag {
+		e, err := envs.Get(env)
+		if err != nil {
+			return fmt.Errorf("error env get - %s", err)
+		}
+		if _, err := queriesmgr.SetGroupLimits(e.ID, name, perNode, total, appName); err != nil {
+			return fmt.Errorf("error set group limits - %s", err)
+		}
+	} else if apiFlag {
+		if err := osctrlAPI.SetQueryGroupLimits(env, types.ApiQueryGroupRequest{Name: name, MaxPerNode: perNode, MaxTotal: total}); err != nil {
+			return fmt.Errorf("error set group limits - %s", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ limits of group %s set successfully\n", name)
+	}
+	return nil
+}
+
+func listQueryPolicies(c *cli.Context) error {
+	// Get values from flags
+	env := c.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	// Retrieve data
+	var policies []queries.QueryPolicy
+	if dbFlag {
+		e, err := envs.Get(env)
+		if err != nil {
+			return fmt.Errorf("error env get - %s", err)
+		}
+		policies, err = queriesmgr.GetPolicies(e.ID)
+		if err != nil {
+			return fmt.Errorf("error get policies - %s", err)
+		}
+	} else if apiFlag {
+		var err error
+		policies, err = osctrlAPI.GetQueryPolicies(env)
+		if err != nil {
+			return fmt.Errorf("error get policies - %s", err)
+		}
+	}
+	header := []string{
+		"Subject",
+		"Mode",
+		"Tables",
+		"Denied columns",
+		"Updated by",
+	}
+	var data [][]string
+	for _, p := range policies {
+		data = append(data, []string{
+			p.Subject,
+			p.Mode,
+			strings.Join(p.TableList(), ", "),
+			strings.Join(p.ColumnList(), ", "),
+			p.UpdatedBy,
+		})
+	}
+	// Prepare output
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(policies)
+		if err != nil {
+			return fmt.Errorf("error json marshal - %s", err)
+		}
+		fmt.Println(string(jsonRaw))
+	} else if formatFlag == csvFormat {
+		w := csv.NewWriter(os.Stdout)
+		if err := w.WriteAll(append([][]string{header}, data...)); err != nil {
+			return fmt.Errorf("error csv writeall - %s", err)
+		}
+	} else if formatFlag == prettyFormat {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader(header)
+		if len(policies) > 0 {
+			fmt.Printf("Existing query policies (%d):\n", len(policies))
+			table.AppendBulk(data)
+		} else {
+			fmt.Printf("No query policies\n")
+		}
+		table.Render()
+	}
+	return nil
+}
+
+func setQueryPolicy(c *cli.Context) error {
+	// Get values from flags
+	subject := c.String("subject")
+	if subject == "" {
+		fmt.Println("❌ subject is required")
+		os.Exit(1)
+	}
+	env := c.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	policy := queries.QueryPolicy{
+		Subject: subject,
+		Mode:    c.String("mode"),
+		Tables:  c.String("tables"),
+		Columns: c.String("columns"),
+	}
+	if err := queries.ValidatePolicy(policy); err != nil {
+		fmt.Printf("❌ %s\n", err)
+		os.Exit(1)
+	}
+	if dbFlag {
+		e, err := envs.Get(env)
+		if err != nil {
+			return fmt.Errorf("error env get - %s", err)
+		}
+		if _, err := queriesmgr.SetPolicy(e.ID, policy, appName); err != nil {
+			return fmt.Errorf("error set policy - %s", err)
+		}
+		if err := envs.RecordActivity(e.ID, environments.ActivityPolicies, appName, "query policy for "+subject+" set", "", fmt.Sprintf("mode: %s\ntables: %s\ncolumns: %s", policy.Mode, policy.Tables, policy.Columns)); err != nil {
+			return fmt.Errorf("error record activity - %s", err)
+		}
+	} else if apiFlag {
+		if err := osctrlAPI.SetQueryPolicy(env, types.ApiQueryPolicyRequest{
+			Subject: subject,
+			Mode:    policy.Mode,
+			Tables:  policy.TableList(),
+			Columns: policy.ColumnList(),
+		}); err != nil {
+			return fmt.Errorf("error set policy - %s", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ query policy for %s set successfully\n", subject)
+	}
+	return nil
+}
+
+func deleteQueryPolicy(c *cli.Context) error {
+	// Get values from flags
+	subject := c.String("subject")
+	if subject == "" {
+		fmt.Println("❌ subject is required")
+		os.Exit(1)
+	}
+	env := c.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	if dbFlag {
+		e, err := envs.Get(env)
+		if err != nil {
+			return fmt.Errorf("error env get - %s", err)
+		}
+		if err := queriesmgr.DeletePolicy(e.ID, subject); err != nil {
+			return fmt.Errorf("error delete policy - %s", err)
+		}
+		if err := envs.RecordActivity(e.ID, environments.ActivityPolicies, appName, "query policy for "+subject+" deleted", "", ""); err != nil {
+			return fmt.Errorf("error record activity - %s", err)
+		}
+	} else if apiFlag {
+		if err := osctrlAPI.DeleteQueryPolicy(env, subject); err != nil {
+			return fmt.Errorf("error delete policy - %s", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ query policy for %s deleted successfully\n", subject)
+	}
+	return nil
+}
+
+// Helper to get the investigation context of a query from flags
+func queryContextFlags(c *cli.Context) (queries.QueryContext, error) {
+	labels, err := queries.ParseLabels(c.StringSlice("label"))
+	if err != nil {
+		return queries.QueryContext{}, err
+	}
+	qc := queries.QueryContext{
+		Description: c.String("description"),
+		TicketID:    c.String("ticket-id"),
+		TicketURL:   c.String("ticket-url"),
+		Labels:      labels,
+	}
+	return qc, queries.ValidateContext(qc)
+}
+
+func queryContext(c *cli.Context) error {
+	// Get values from flags
+	name := c.String("name")
+	if name == "" {
+		fmt.Println("❌ query name is required")
+		os.Exit(1)
+	}
+	env := c.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	qc, err := queryContextFlags(c)
+	if err != nil {
+		fmt.Printf("❌ %s\n", err)
+		os.Exit(1)
+	}
+	if dbFlag {
+		e, err := envs.Get(env)
+		if err != nil {
+			return fmt.Errorf("error env get - %s", err)
+		}
+		before, err := queriesmgr.SetContext(name, e.ID, qc)
+		if err != nil {
+			return fmt.Errorf("error set context - %s", err)
+		}
+		if err := envs.RecordActivity(e.ID, environments.ActivityQueries, appName, "context of query "+name+" changed", queries.SerializeContext(before), queries.SerializeContext(qc)); err != nil {
+			return fmt.Errorf("error record activity - %s", err)
+		}
+	} else if apiFlag {
+		if err := osctrlAPI.SetQueryContext(env, name, qc); err != nil {
+			return fmt.Errorf("error set context - %s", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ context of query %s saved successfully", name)
+	}
+	return nil
+}
+
+// Interval to check if a sharing export requested to the API is generated
+const shareWait = 2 * time.Second
+
+// Helper to iterate the rows of the results of a query in the DB, with the node and the time they were collected
+func dbQueryRows(name string, fn func(node string, collected time.Time, row map[string]string) error) error {
+	var logs []logging.OsqueryQueryData
+	if err := db.Conn.Where("name = ?", name).Order("id").Find(&logs).Error; err != nil {
+		return err
+	}
+	for _, l := range logs {
+		var data types.QueryWriteData
+		if err := json.Unmarshal([]byte(l.Data), &data); err != nil {
+			continue
+		}
+		rows, err := queries.ParseResultRows(data.Result)
+		if err != nil {
+			continue
+		}
+		for _, row := range rows {
+			if err := fn(l.UUID, l.CreatedAt, row); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func shareQuery(c *cli.Context) error {
+	// Get values from flags
+	names := c.StringSlice("name")
+	if len(names) == 0 {
+		fmt.Println("❌ query name is required")
+		os.Exit(1)
+	}
+	env := c.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	output := c.String("output")
+	if output == "" {
+		fmt.Println("❌ output is required")
+		os.Exit(1)
+	}
+	var denyColumns []string
+	if c.IsSet("deny-column") {
+		denyColumns = []string{}
+		for _, d := range c.StringSlice("deny-column") {
+			if d != "" {
+				denyColumns = append(denyColumns, d)
+			}
+		}
+	}
+	var share queries.ShareExport
+	if dbFlag {
+		e, err := envs.Get(env)
+		if err != nil {
+			return fmt.Errorf("error env get - %s", err)
+		}
+		req := queries.ShareRequest{
+			EnvironmentID: e.ID,
+			Environment:   e.Name,
+			Queries:       names,
+			Format:        c.String("format"),
+			Redaction:     queries.ShareRedaction{DropIPs: c.Bool("drop-ips"), DenyColumns: denyColumns},
+			Creator:       appName,
+		}
+		share, err = queriesmgr.NewShare(&req)
+		if err != nil {
+			return fmt.Errorf("error new share - %s", err)
+		}
+		src := queries.ShareSource{
+			Query: func(name string) (queries.DistributedQuery, error) {
+				query, err := queriesmgr.Get(name, e.ID)
+				if err == nil && query.ID == 0 {
+					err = fmt.Errorf("record not found")
+				}
+				return query, err
+			},
+			Rows: dbQueryRows,
+			Node: func(uuid string) (nodes.OsqueryNode, error) {
+				return nodesmgr.GetByUUIDEnv(uuid, e.ID)
+			},
+		}
+		manifest, err := queriesmgr.GenerateShare(context.Background(), share, src)
+		if err != nil {
+			_ = envs.RecordActivity(e.ID, environments.ActivityShares, appName, share.Summary()+" failed", "", err.Error())
+			return fmt.Errorf("error generate share - %s", err)
+		}
+		scope, err := json.Marshal(manifest)
+		if err != nil {
+			return fmt.Errorf("error json marshal - %s", err)
+		}
+		if err := envs.RecordActivity(e.ID, environments.ActivityShares, appName, share.Summary()+" generated", "", string(scope)); err != nil {
+			return fmt.Errorf("error record activity - %s", err)
+		}
+		if share, err = queriesmgr.GetShare(share.Name, e.ID); err != nil {
+			return fmt.Errorf("error get share - %s", err)
+		}
+	} else if apiFlag {
+		share, err = osctrlAPI.ShareQueries(env, types.ApiShareRequest{
+			Queries:     names,
+			Format:      c.String("format"),
+			DropIPs:     c.Bool("drop-ips"),
+			DenyColumns: denyColumns,
+		})
+		if err != nil {
+			return fmt.Errorf("error share queries - %s", err)
+		}
+		// Large results take a while, wait until the export is generated
+		for share.Status == queries.SharePending {
+			time.Sleep(shareWait)
+			if share, err = osctrlAPI.GetShare(env, share.Name); err != nil {
+				return fmt.Errorf("error get share - %s", err)
+			}
+		}
+		if share.Status == queries.ShareReady {
+			if share.Content, err = osctrlAPI.DownloadShare(env, share.Name); err != nil {
+				return fmt.Errorf("error download share - %s", err)
+			}
+		}
+	}
+	if share.Status != queries.ShareReady {
+		return fmt.Errorf("sharing export %s failed - %s", share.Name, share.Error)
+	}
+	if err := os.WriteFile(output, share.Content, 0600); err != nil {
+		return fmt.Errorf("error writing share - %s", err)
+	}
+	if !silentFlag {
+		fmt.Printf("✅ sharing export %s written to %s\n", share.Name, output)
+		fmt.Printf("✅ sha256 %s\n", share.SHA256)
+	}
+	return nil
+}
+
+// Interval to check if an export job requested to the API is materialized, also the wait between retries of downloads
+const exportWait = 2 * time.Second
+
+// Helper to get the SHA-256 of a file
+func fileSHA256(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Helper to wait for an export job to be materialized and download it in ranges, retrying from where it stopped.
+// The output is verified with the SHA-256 of the export job, with resume the output is continued if it exists.
+func downloadExportJob(env string, job queries.ExportJob, output string, retries int, resume bool) error {
+	var err error
+	if !silentFlag {
+		fmt.Printf("export job %s of %d rows is %s\n", job.JobID, job.Rows, job.Status)
+	}
+	for job.Active() {
+		time.Sleep(exportWait)
+		if job, err = osctrlAPI.GetExportJob(env, job.JobID); err != nil {
+			return fmt.Errorf("error get export job - %s", err)
+		}
+	}
+	if job.Status != queries.ExportJobReady {
+		return fmt.Errorf("export job %s is %s %s", job.JobID, job.Status, job.Error)
+	}
+	flags := os.O_CREATE | os.O_WRONLY
+	if !resume {
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(output, flags, 0600)
+	if err != nil {
+		return fmt.Errorf("error opening output - %s", err)
+	}
+	defer f.Close()
+	for attempt := 0; ; attempt++ {
+		offset, err := f.Seek(0, io.SeekEnd)
+		if err != nil {
+			return fmt.Errorf("error seeking output - %s", err)
+		}
+		// Output bigger than the artifact is from something else, it starts over
+		if offset > job.Size {
+			if err := f.Truncate(0); err != nil {
+				return fmt.Errorf("error truncating output - %s", err)
+			}
+			offset = 0
+		}
+		if offset > 0 && attempt == 0 && !silentFlag {
+			fmt.Printf("resuming download of %s from %d of %d bytes\n", job.JobID, offset, job.Size)
+		}
+		if offset < job.Size {
+			_, err = osctrlAPI.DownloadExportRange(env, job.JobID, offset, f)
+		}
+		if err == nil {
+			var sum string
+			if sum, err = fileSHA256(output); err == nil && sum == job.SHA256 {
+				break
+			}
+			if err == nil {
+				err = fmt.Errorf("sha256 %s does not match %s", sum, job.SHA256)
+				// Corrupted output is downloaded again from the start
+				if tErr := f.Truncate(0); tErr != nil {
+					return fmt.Errorf("error truncating output - %s", tErr)
+				}
+			}
+		}
+		if attempt >= retries {
+			return fmt.Errorf("error downloading export job %s - %s", job.JobID, err)
+		}
+		if !silentFlag {
+			fmt.Printf("⚠️ download of %s failed, retrying - %s\n", job.JobID, err)
+		}
+		time.Sleep(exportWait * time.Duration(attempt+1))
+	}
+	if !silentFlag {
+		fmt.Printf("✅ export job %s written to %s\n", job.JobID, output)
+		fmt.Printf("✅ sha256 %s verified\n", job.SHA256)
+	}
+	return nil
+}
+
+func exportQuery(c *cli.Context) error {
+	// Get values from flags
+	name := c.String("name")
+	if name == "" {
+		fmt.Println("❌ query name is required")
+		os.Exit(1)
+	}
+	env := c.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	output := c.String("output")
+	if output == "" {
+		fmt.Println("❌ output is required")
+		os.Exit(1)
+	}
+	format := c.String("format")
+	if !queries.ValidExportFormat(format) {
+		fmt.Printf("❌ format must be one of %s\n", strings.Join(queries.ExportFormats, ", "))
+		os.Exit(1)
+	}
+	if dbFlag {
+		inference := queries.NewSchemaInference()
+		if err := dbQueryRows(name, func(node string, collected time.Time, row map[string]string) error {
+			inference.Add(row)
+			return nil
+		}); err != nil {
+			return fmt.Errorf("error reading results - %s", err)
+		}
+		f, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			return fmt.Errorf("error opening output - %s", err)
+		}
+		defer f.Close()
+		artifact, err := queries.MaterializeExport(f, format, inference.Schema(), func(fn func(node string, collected time.Time, row map[string]string) error) error {
+			return dbQueryRows(name, fn)
+		}, nil)
+		if err != nil {
+			return fmt.Errorf("error exporting results - %s", err)
+		}
+		if !silentFlag {
+			fmt.Printf("✅ %d results of %s written to %s\n", artifact.Rows, name, output)
+			fmt.Printf("✅ sha256 %s\n", artifact.SHA256)
+		}
+	} else if apiFlag {
+		resume := c.Bool("resume")
+		var job *queries.ExportJob
+		if resume {
+			if job, err = osctrlAPI.ExportQuery(env, name, format, true, io.Discard); err != nil {
+				return fmt.Errorf("error export query - %s", err)
+			}
+		} else {
+			// Small results are streamed to the output, large ones come as an export job
+			f, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+			if err != nil {
+				return fmt.Errorf("error opening output - %s", err)
+			}
+			job, err = osctrlAPI.ExportQuery(env, name, format, false, f)
+			if cErr := f.Close(); err == nil {
+				err = cErr
+			}
+			if err != nil {
+				return fmt.Errorf("error export query - %s", err)
+			}
+			if job == nil {
+				if !silentFlag {
+					fmt.Printf("✅ results of %s written to %s\n", name, output)
+				}
+				return nil
+			}
+		}
+		return downloadExportJob(env, *job, output, c.Int("retries"), resume)
+	}
+	return nil
+}
+
+func profileQuery(c *cli.Context) error {
+	// Get values from flags
+	name := c.String("name")
+	if name == "" {
+		fmt.Println("❌ query name is required")
+		os.Exit(1)
+	}
+	env := c.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	// Retrieve data
+	var report queries.ProfileReport
+	if dbFlag {
+		e, err := envs.Get(env)
+		if err != nil {
+			return fmt.Errorf("error env get - %s", err)
+		}
+		report, err = queriesmgr.GetProfileReport(name, e.ID)
+		if err != nil {
+			return fmt.Errorf("error get profile - %s", err)
+		}
+	} else if apiFlag {
+		report, err = osctrlAPI.GetQueryProfile(env, name)
+		if err != nil {
+			return fmt.Errorf("error get profile - %s", err)
+		}
+	}
+	header := []string{
+		"Name",
+		"Samples",
+		"Errors",
+		"Targeted",
+		"Wall min (ms)",
+		"Wall p50 (ms)",
+		"Wall p90 (ms)",
+		"Wall max (ms)",
+		"Memory max",
+		"Rows per node",
+		"Projected rows",
+		"Launched",
+	}
+	data := [][]string{
+		{
+			report.Name,
+			strconv.Itoa(report.Samples),
+			strconv.Itoa(report.Errors),
+			strconv.Itoa(report.Targeted),
+			strconv.FormatInt(report.WallTimeMin, 10),
+			strconv.FormatInt(report.WallTimeP50, 10),
+			strconv.FormatInt(report.WallTimeP90, 10),
+			strconv.FormatInt(report.WallTimeMax, 10),
+			strconv.FormatInt(report.MemoryMax, 10),
+			strconv.FormatFloat(report.RowsAvg, 'f', 1, 64),
+			strconv.FormatInt(report.ProjectedRows, 10),
+			report.Launched,
+		},
+	}
+	// Prepare output
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(report)
+		if err != nil {
+			return fmt.Errorf("error json marshal - %s", err)
+		}
+		fmt.Println(string(jsonRaw))
+	} else if formatFlag == csvFormat {
+		w := csv.NewWriter(os.Stdout)
+		if err := w.WriteAll(append([][]string{header}, data...)); err != nil {
+			return fmt.Errorf("error csv writeall - %s", err)
+		}
+	} else if formatFlag == prettyFormat {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader(header)
+		table.AppendBulk(data)
+		table.Render()
+	}
+	return nil
+}
+
+func launchQuery(c *cli.Context) error {
+	// Get values from flags
+	name := c.String("name")
+	if name == "" {
+		fmt.Println("❌ query name is required")
+		os.Exit(1)
+	}
+	env := c.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	var launched string
+	if dbFlag {
+		e, err := envs.Get(env)
+		if err != nil {
+			return fmt.Errorf("error env get - %s", err)
+		}
+		q, err := queriesmgr.LaunchProfile(name, e.ID, appName)
+		if err != nil {
+			return fmt.Errorf("error launch profile - %s", err)
+		}
+		launched = q.Name
+	} else if apiFlag {
+		q, err := osctrlAPI.LaunchQueryProfile(env, name)
+		if err != nil {
+			return fmt.Errorf("error launch profile - %s", err)
+		}
+		launched = q.Name
+	}
+	if !silentFlag {
+		fmt.Printf("✅ query %s launched successfully", launched)
+	}
+	return nil
+}
+
+// Helper to get the time window to target nodes from flags, as RFC3339 bounds
+func windowFlags(c *cli.Context) (queries.TargetWindow, error) {
+	return queries.ParseTargetWindow(c.String("enrolled-after"), c.String("enrolled-before"), c.String("seen-after"), c.String("seen-before"))
+}
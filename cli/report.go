@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/reports"
+	"github.com/olekukonko/tablewriter"
+	"github.com/urfave/cli/v2"
+)
+
+// Helper function to convert a slice of reports into the data expected for output
+func reportsToData(rs []reports.Report, header []string) [][]string {
+	var data [][]string
+	if header != nil {
+		data = append(data, header)
+	}
+	for _, r := range rs {
+		data = append(data, reportToData(r, nil)...)
+	}
+	return data
+}
+
+func reportToData(r reports.Report, header []string) [][]string {
+	var data [][]string
+	if header != nil {
+		data = append(data, header)
+	}
+	_r := []string{
+		strconv.FormatUint(uint64(r.ID), 10),
+		r.Name,
+		r.Environment,
+		r.Period,
+		r.Format,
+		r.Storage,
+		strconv.FormatInt(r.Size, 10),
+		r.Creator,
+		r.CreatedAt.Format("2006-01-02 15:04:05"),
+	}
+	data = append(data, _r)
+	return data
+}
+
+func listReports(c *cli.Context) error {
+	// Retrieve data
+	var rs []reports.Report
+	if dbFlag {
+		rs, err = reportsmgr.Gets(0)
+		if err != nil {
+			return fmt.Errorf("error get reports - %s", err)
+		}
+	} else if apiFlag {
+		rs, err = osctrlAPI.GetReports()
+		if err != nil {
+			return fmt.Errorf("error get reports - %s", err)
+		}
+	}
+	header := []string{
+		"ID",
+		"Name",
+		"Environment",
+		"Period",
+		"Format",
+		"Storage",
+		"Size",
+		"Creator",
+		"Generated",
+	}
+	// Prepare output
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(rs)
+		if err != nil {
+			return fmt.Errorf("error json marshal - %s", err)
+		}
+		fmt.Println(string(jsonRaw))
+	} else if formatFlag == csvFormat {
+		data := reportsToData(rs, header)
+		w := csv.NewWriter(os.Stdout)
+		if err := w.WriteAll(data); err != nil {
+			return fmt.Errorf("error csv writeall - %s", err)
+		}
+	} else if formatFlag == prettyFormat {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader(header)
+		if len(rs) > 0 {
+			fmt.Printf("Existing reports (%d):\n", len(rs))
+			data := reportsToData(rs, nil)
+			table.AppendBulk(data)
+		} else {
+			fmt.Printf("No reports\n")
+		}
+		table.Render()
+	}
+	return nil
+}
+
+func generateReport(c *cli.Context) error {
+	// Get values from flags
+	env := c.String("env")
+	if env == "" {
+		env = reports.FleetWide
+	}
+	period := c.String("period")
+	if period == "" {
+		fmt.Println("❌ period is required")
+		os.Exit(1)
+	}
+	category := c.String("category")
+	if !environments.IsCategory(category) {
+		fmt.Printf("❌ invalid category %s\n", category)
+		os.Exit(1)
+	}
+	format := c.String("format")
+	output := c.String("output")
+	var report reports.Report
+	var content []byte
+	if dbFlag {
+		req := reports.ReportRequest{
+			Environment:   env,
+			Category:      category,
+			Period:        period,
+			Format:        format,
+			Creator:       appName,
+			InactiveHours: settingsmgr.InactiveHours(),
+		}
+		if env != reports.FleetWide {
+			e, err := envs.Get(env)
+			if err != nil {
+				return fmt.Errorf("error env get - %s", err)
+			}
+			req.EnvironmentID = e.ID
+			req.Locale = e.Locale
+		}
+		reportsmgr.PDFConverter = c.String("pdf")
+		report, err = reportsmgr.Generate(context.Background(), req)
+		if err != nil {
+			return fmt.Errorf("error generate report - %s", err)
+		}
+		content = report.Content
+	} else if apiFlag {
+		report, err = osctrlAPI.GenerateReport(env, category, period, format)
+		if err != nil {
+			return fmt.Errorf("error generate report - %s", err)
+		}
+		if output != "" {
+			content, err = osctrlAPI.DownloadReport(report.ID)
+			if err != nil {
+				return fmt.Errorf("error download report - %s", err)
+			}
+		}
+	}
+	if output != "" {
+		if err := os.WriteFile(output, content, 0644); err != nil {
+			return fmt.Errorf("error writing report - %s", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ report %s was generated successfully\n", report.Name)
+		if output != "" {
+			fmt.Printf("✅ report written to %s\n", output)
+		}
+	}
+	return nil
+}
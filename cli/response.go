@@ -0,0 +1,541 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/responses"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/olekukonko/tablewriter"
+	"github.com/urfave/cli/v2"
+)
+
+// Helper to print data in the output format, with a message before the table in pretty format
+func printResponsesData(v interface{}, header []string, data [][]string, title, empty string) error {
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("error marshaling - %s", err)
+		}
+		fmt.Println(string(jsonRaw))
+	} else if formatFlag == csvFormat {
+		w := csv.NewWriter(os.Stdout)
+		if err := w.WriteAll(append([][]string{header}, data...)); err != nil {
+			return fmt.Errorf("error writting csv - %s", err)
+		}
+	} else if formatFlag == prettyFormat {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader(header)
+		if len(data) > 0 {
+			fmt.Println(title)
+			table.AppendBulk(data)
+		} else {
+			fmt.Println(empty)
+		}
+		table.Render()
+	}
+	return nil
+}
+
+// Helper to get the environment name from flags, exiting when it is missing
+func responsesEnv(c *cli.Context) string {
+	envName := c.String("env")
+	if envName == "" {
+		fmt.Println("❌ Environment is required")
+		os.Exit(1)
+	}
+	return envName
+}
+
+func listResponseActions(c *cli.Context) error {
+	envName := responsesEnv(c)
+	var actions []responses.ResponseAction
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		actions, err = responsesmgr.Actions(env.ID, c.Int("limit"))
+		if err != nil {
+			return fmt.Errorf("error getting response actions - %s", err)
+		}
+	} else if apiFlag {
+		actions, err = osctrlAPI.GetResponseActions(envName, c.Int("limit"))
+		if err != nil {
+			return fmt.Errorf("error getting response actions - %s", err)
+		}
+	}
+	header := []string{
+		"Created",
+		"Trigger",
+		"Source",
+		"Node",
+		"Kind",
+		"Target",
+		"Status",
+		"Query",
+		"Message",
+	}
+	data := [][]string{}
+	for _, a := range actions {
+		data = append(data, []string{
+			a.CreatedAt.Format(time.RFC3339),
+			a.Trigger,
+			a.Source,
+			a.NodeUUID,
+			a.Kind,
+			a.Target,
+			a.Status,
+			a.QueryName,
+			a.Message,
+		})
+	}
+	return printResponsesData(actions, header, data, fmt.Sprintf("Latest response actions in %s (%d):", envName, len(actions)), "No response actions in "+envName)
+}
+
+// Helper to get the response actions configuration and triggers of an environment from the DB
+func dbEnvResponses(env environments.TLSEnvironment) (responses.EnvResponses, error) {
+	cfg, err := responsesmgr.Config(env.ID)
+	if err != nil {
+		return responses.EnvResponses{}, fmt.Errorf("error getting response actions configuration - %s", err)
+	}
+	triggers, err := responsesmgr.Triggers(env.ID)
+	if err != nil {
+		return responses.EnvResponses{}, fmt.Errorf("error getting response triggers - %s", err)
+	}
+	return responses.EnvResponses{Config: cfg, Triggers: triggers}, nil
+}
+
+func showResponseConfig(c *cli.Context) error {
+	envName := responsesEnv(c)
+	var current responses.EnvResponses
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		current, err = dbEnvResponses(env)
+		if err != nil {
+			return err
+		}
+	} else if apiFlag {
+		current, err = osctrlAPI.GetResponseConfig(envName)
+		if err != nil {
+			return fmt.Errorf("error getting response actions configuration - %s", err)
+		}
+	}
+	cfg := current.Config
+	tripped := ""
+	if !cfg.TrippedAt.IsZero() {
+		tripped = cfg.TrippedAt.Format(time.RFC3339)
+	}
+	header := []string{
+		"Enabled",
+		"Allowed",
+		"Cooldown",
+		"Max Per Hour",
+		"Circuit Open",
+	}
+	data := [][]string{
+		{
+			stringifyBool(cfg.Enabled),
+			cfg.Allowed,
+			strconv.Itoa(cfg.Cooldown),
+			strconv.Itoa(cfg.MaxPerHour),
+			tripped,
+		},
+	}
+	return printResponsesData(cfg, header, data, "Response actions in "+envName+":", "")
+}
+
+func setResponseConfig(c *cli.Context) error {
+	envName := responsesEnv(c)
+	req := types.ApiResponseConfigRequest{
+		Enabled:    c.Bool("enable"),
+		Allowed:    c.String("allowed"),
+		Cooldown:   c.Int("cooldown"),
+		MaxPerHour: c.Int("max-per-hour"),
+		Reset:      c.Bool("reset"),
+	}
+	msg := "response actions configuration saved"
+	if req.Reset {
+		msg = "response actions circuit breaker reset"
+	}
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		before, err := responsesmgr.Config(env.ID)
+		if err != nil {
+			return fmt.Errorf("error getting response actions configuration - %s", err)
+		}
+		after := before
+		if req.Reset {
+			if err := responsesmgr.ResetCircuit(env.ID); err != nil {
+				return fmt.Errorf("error resetting circuit breaker - %s", err)
+			}
+		} else {
+			after, err = responsesmgr.SetConfig(env.ID, responses.ResponseConfig{
+				Enabled:    req.Enabled,
+				Allowed:    req.Allowed,
+				Cooldown:   req.Cooldown,
+				MaxPerHour: req.MaxPerHour,
+			})
+			if err != nil {
+				return fmt.Errorf("error saving response actions configuration - %s", err)
+			}
+		}
+		if err := envs.RecordActivity(env.ID, environments.ActivityResponses, appName, msg, responses.SerializeConfig(before), responses.SerializeConfig(after)); err != nil {
+			log.Printf("error recording activity - %s", err)
+		}
+	} else if apiFlag {
+		if _, err := osctrlAPI.SetResponseConfig(envName, req); err != nil {
+			return fmt.Errorf("error saving response actions configuration - %s", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ %s in %s\n", msg, envName)
+	}
+	return nil
+}
+
+func listResponseTriggers(c *cli.Context) error {
+	envName := responsesEnv(c)
+	var current responses.EnvResponses
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		current, err = dbEnvResponses(env)
+		if err != nil {
+			return err
+		}
+	} else if apiFlag {
+		current, err = osctrlAPI.GetResponseConfig(envName)
+		if err != nil {
+			return fmt.Errorf("error getting response triggers - %s", err)
+		}
+	}
+	header := []string{
+		"Name",
+		"Query",
+		"Column",
+		"Value",
+		"Kind",
+		"Template",
+		"Variables",
+		"Path",
+		"Enabled",
+		"Creator",
+	}
+	data := [][]string{}
+	for _, t := range current.Triggers {
+		data = append(data, []string{
+			t.Name,
+			t.Query,
+			t.Column,
+			t.Value,
+			t.Kind,
+			t.Template,
+			t.Variables,
+			t.Path,
+			stringifyBool(t.Enabled),
+			t.Creator,
+		})
+	}
+	return printResponsesData(current.Triggers, header, data, fmt.Sprintf("Existing response triggers in %s (%d):", envName, len(current.Triggers)), "No response triggers in "+envName)
+}
+
+func setResponseTrigger(c *cli.Context) error {
+	envName := responsesEnv(c)
+	name := c.String("name")
+	if name == "" {
+		fmt.Println("❌ Trigger name is required")
+		os.Exit(1)
+	}
+	vars, err := parseTemplateValues(c.StringSlice("var"))
+	if err != nil {
+		return err
+	}
+	req := types.ApiResponseTriggerRequest{
+		Name:      name,
+		Query:     c.String("query"),
+		Column:    c.String("column"),
+		Value:     c.String("value"),
+		Kind:      c.String("kind"),
+		Template:  c.String("template"),
+		Variables: vars,
+		Path:      c.String("path"),
+		Enabled:   !c.Bool("disable"),
+	}
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		before, err := responsesmgr.Triggers(env.ID)
+		if err != nil {
+			return fmt.Errorf("error getting response triggers - %s", err)
+		}
+		if _, err := responsesmgr.SetTrigger(env.ID, responses.ResponseTrigger{
+			Name:      req.Name,
+			Query:     req.Query,
+			Column:    req.Column,
+			Value:     req.Value,
+			Kind:      req.Kind,
+			Template:  req.Template,
+			Variables: responses.EncodeVariables(req.Variables),
+			Path:      req.Path,
+			Enabled:   req.Enabled,
+			Creator:   appName,
+		}); err != nil {
+			return fmt.Errorf("error saving response trigger - %s", err)
+		}
+		after, err := responsesmgr.Triggers(env.ID)
+		if err != nil {
+			return fmt.Errorf("error getting response triggers - %s", err)
+		}
+		if err := envs.RecordActivity(env.ID, environments.ActivityResponses, appName, "response trigger "+name+" saved", responses.SerializeTriggers(before), responses.SerializeTriggers(after)); err != nil {
+			log.Printf("error recording activity - %s", err)
+		}
+	} else if apiFlag {
+		if _, err := osctrlAPI.SetResponseTrigger(envName, req); err != nil {
+			return fmt.Errorf("error saving response trigger - %s", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ Response trigger %s saved in %s\n", name, envName)
+	}
+	return nil
+}
+
+func deleteResponseTrigger(c *cli.Context) error {
+	envName := responsesEnv(c)
+	name := c.String("name")
+	if name == "" {
+		fmt.Println("❌ Trigger name is required")
+		os.Exit(1)
+	}
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		before, err := responsesmgr.Triggers(env.ID)
+		if err != nil {
+			return fmt.Errorf("error getting response triggers - %s", err)
+		}
+		if err := responsesmgr.DeleteTrigger(env.ID, name); err != nil {
+			return fmt.Errorf("error deleting response trigger - %s", err)
+		}
+		after, err := responsesmgr.Triggers(env.ID)
+		if err != nil {
+			return fmt.Errorf("error getting response triggers - %s", err)
+		}
+		if err := envs.RecordActivity(env.ID, environments.ActivityResponses, appName, "response trigger "+name+" deleted", responses.SerializeTriggers(before), responses.SerializeTriggers(after)); err != nil {
+			log.Printf("error recording activity - %s", err)
+		}
+	} else if apiFlag {
+		if _, err := osctrlAPI.DeleteResponseTrigger(envName, name); err != nil {
+			return fmt.Errorf("error deleting response trigger - %s", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ Response trigger %s deleted from %s\n", name, envName)
+	}
+	return nil
+}
+
+func sendResponseAlert(c *cli.Context) error {
+	envName := responsesEnv(c)
+	name := c.String("trigger")
+	if name == "" {
+		fmt.Println("❌ Trigger name is required")
+		os.Exit(1)
+	}
+	uuid := c.String("uuid")
+	if uuid == "" {
+		fmt.Println("❌ UUID is required")
+		os.Exit(1)
+	}
+	fields, err := parseTemplateValues(c.StringSlice("field"))
+	if err != nil {
+		return err
+	}
+	var action responses.ResponseAction
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		trigger, err := responsesmgr.GetTrigger(env.ID, name)
+		if err != nil {
+			return fmt.Errorf("error getting response trigger - %s", err)
+		}
+		if !trigger.Enabled {
+			return fmt.Errorf("response trigger %s is disabled", name)
+		}
+		node, err := nodesmgr.GetByIdentifier(uuid)
+		if err != nil || node.EnvironmentID != env.ID {
+			return fmt.Errorf("node %s not found in %s", uuid, envName)
+		}
+		action, err = responsesmgr.Fire(trigger, node, fields, responses.SourceAlert)
+		if err != nil {
+			return fmt.Errorf("error firing response trigger - %s", err)
+		}
+	} else if apiFlag {
+		action, err = osctrlAPI.SendResponseAlert(envName, types.ApiResponseAlertRequest{
+			Trigger: name,
+			UUID:    uuid,
+			Event:   fields,
+		})
+		if err != nil {
+			return fmt.Errorf("error firing response trigger - %s", err)
+		}
+	}
+	if !silentFlag {
+		if action.Status == responses.StatusLaunched {
+			fmt.Printf("✅ Response trigger %s launched %s %s for %s\n", name, action.Kind, action.QueryName, uuid)
+		} else {
+			fmt.Printf("⚠️  Response trigger %s not launched for %s: %s %s\n", name, uuid, action.Status, action.Message)
+		}
+	}
+	return nil
+}
+
+func listMutes(c *cli.Context) error {
+	envName := responsesEnv(c)
+	var mutes []responses.Mute
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		mutes, err = responsesmgr.Mutes(env.ID, time.Now())
+		if err != nil {
+			return fmt.Errorf("error getting mutes - %s", err)
+		}
+	} else if apiFlag {
+		mutes, err = osctrlAPI.GetMutes(envName)
+		if err != nil {
+			return fmt.Errorf("error getting mutes - %s", err)
+		}
+	}
+	header := []string{
+		"ID",
+		"Muted",
+		"Reason",
+		"Creator",
+		"Expires",
+		"Suppressed",
+	}
+	data := [][]string{}
+	now := time.Now()
+	for _, m := range mutes {
+		expires := "permanent"
+		if !m.Permanent {
+			expires = m.ExpiresAt.Format(time.RFC3339)
+			if !m.Active(now) {
+				expires += " (expired)"
+			}
+		}
+		data = append(data, []string{
+			strconv.FormatUint(uint64(m.ID), 10),
+			m.Scope(),
+			m.Reason,
+			m.Creator,
+			expires,
+			strconv.FormatInt(m.Suppressed, 10),
+		})
+	}
+	title := fmt.Sprintf("Mutes in %s (%d), %d events suppressed:", envName, len(mutes), responses.SuppressedTally(mutes))
+	return printResponsesData(mutes, header, data, title, "No mutes in "+envName)
+}
+
+func setMute(c *cli.Context) error {
+	envName := responsesEnv(c)
+	req := types.ApiMuteRequest{
+		Kind:      c.String("kind"),
+		Rule:      c.String("rule"),
+		UUID:      c.String("uuid"),
+		Reason:    c.String("reason"),
+		Hours:     c.Int("hours"),
+		Permanent: c.Bool("permanent"),
+		Notify:    c.Bool("notify"),
+	}
+	var mute responses.Mute
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		now := time.Now()
+		mute = responses.Mute{
+			EnvironmentID: env.ID,
+			NodeUUID:      req.UUID,
+			Kind:          req.Kind,
+			Rule:          req.Rule,
+			Reason:        req.Reason,
+			Creator:       appName,
+			Permanent:     req.Permanent,
+			NotifyExpiry:  req.Notify && !req.Permanent,
+		}
+		if !req.Permanent {
+			mute.ExpiresAt = now.Add(time.Duration(req.Hours) * time.Hour)
+		}
+		mute, err = responsesmgr.SetMute(mute, now)
+		if err != nil {
+			return fmt.Errorf("error saving mute - %s", err)
+		}
+		if err := envs.RecordActivity(env.ID, environments.ActivityMutes, appName, "muted "+mute.Scope()+": "+mute.Reason, "", ""); err != nil {
+			log.Printf("error recording activity - %s", err)
+		}
+	} else if apiFlag {
+		mute, err = osctrlAPI.SetMute(envName, req)
+		if err != nil {
+			return fmt.Errorf("error saving mute - %s", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ Muted %s in %s with id %d\n", mute.Scope(), envName, mute.ID)
+	}
+	return nil
+}
+
+func deleteMute(c *cli.Context) error {
+	envName := responsesEnv(c)
+	id := c.Uint("id")
+	if id == 0 {
+		fmt.Println("❌ Mute id is required")
+		os.Exit(1)
+	}
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		lifted, err := responsesmgr.DeleteMute(env.ID, id)
+		if err != nil {
+			return fmt.Errorf("error deleting mute - %s", err)
+		}
+		if err := envs.RecordActivity(env.ID, environments.ActivityMutes, appName, "unmuted "+lifted.Scope(), "", ""); err != nil {
+			log.Printf("error recording activity - %s", err)
+		}
+	} else if apiFlag {
+		if _, err := osctrlAPI.DeleteMute(envName, id); err != nil {
+			return fmt.Errorf("error deleting mute - %s", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ Mute %d lifted in %s\n", id, envName)
+	}
+	return nil
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jmpsec/osctrl/carves"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/olekukonko/tablewriter"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	// Status of the steps of the smoke test
+	smokePass = "PASS"
+	smokeFail = "FAIL"
+	smokeSkip = "SKIP"
+	// Interval to poll osctrl while waiting for the synthetic node
+	smokePollInterval = 2 * time.Second
+	// Query sent to the synthetic node
+	smokeQuery = "SELECT 1 AS smoke;"
+	// Name of the scheduled query for the result logs
+	smokeResultName = "osctrl_smoke_test"
+)
+
+// smokeStep to keep the outcome of each step of the smoke test
+type smokeStep struct {
+	Name     string        `json:"name"`
+	Status   string        `json:"status"`
+	Duration time.Duration `json:"duration"`
+	Detail   string        `json:"detail"`
+}
+
+// smokeRun to execute the steps of the smoke test in order, skipping them once one fails
+type smokeRun struct {
+	Steps  []smokeStep
+	failed bool
+}
+
+// Helper to run one step of the smoke test, timing it
+func (s *smokeRun) step(name string, fn func() (string, error)) {
+	if s.failed {
+		s.Steps = append(s.Steps, smokeStep{Name: name, Status: smokeSkip, Detail: "previous step failed"})
+		return
+	}
+	start := time.Now()
+	detail, err := fn()
+	st := smokeStep{Name: name, Status: smokePass, Duration: time.Since(start), Detail: detail}
+	if err != nil {
+		st.Status = smokeFail
+		st.Detail = err.Error()
+		s.failed = true
+	}
+	s.Steps = append(s.Steps, st)
+}
+
+// Helper to run one step of the cleanup, even if previous steps failed
+func (s *smokeRun) cleanup(name string, fn func() (string, error)) {
+	failed := s.failed
+	s.failed = false
+	s.step(name, fn)
+	s.failed = failed || s.failed
+}
+
+// Helper to wait until a query is pending for the synthetic node
+func smokeWaitQuery(agent *syntheticAgent, name string, timeout time.Duration) (string, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		qs, err := agent.Read()
+		if err != nil {
+			return "", err
+		}
+		if q, ok := qs[name]; ok {
+			return q, nil
+		}
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("query %s not received after %s", name, timeout)
+		}
+		time.Sleep(smokePollInterval)
+	}
+}
+
+// Action to validate a deployment end to end, with a synthetic node that enrolls, gets configuration and flags,
+// sends logs and answers an on-demand query (and a carve) that are checked through the API
+func smokeTest(c *cli.Context) error {
+	if !formats[formatFlag] {
+		return fmt.Errorf("invalid format %s", formatFlag)
+	}
+	// Get values from flags
+	tlsURL := c.String("tls-url")
+	if tlsURL == "" {
+		fmt.Println("❌ TLS URL is required")
+		os.Exit(1)
+	}
+	env := c.String("env")
+	if env == "" {
+		fmt.Println("❌ environment is required")
+		os.Exit(1)
+	}
+	secret := c.String("secret")
+	if secret == "" {
+		fmt.Println("❌ enroll secret is required")
+		os.Exit(1)
+	}
+	// API values from flags or from the configuration file
+	if apiConfig.URL == "" && apiConfigFile != "" {
+		if apiConfig, err = loadAPIConfiguration(apiConfigFile); err != nil {
+			return fmt.Errorf("loadAPIConfiguration - %v", err)
+		}
+	}
+	if apiConfig.URL == "" || apiConfig.Token == "" {
+		fmt.Println("❌ API URL and token are required")
+		os.Exit(1)
+	}
+	osctrlAPI = CreateAPI(apiConfig, insecureFlag)
+	timeout := c.Duration("timeout")
+	agent := newSyntheticAgent(tlsURL, env, secret, c.String("hostname-prefix"), insecureFlag)
+	// The agent uses the paths of the environment, they may not be the defaults
+	envAll, err := osctrlAPI.GetEnvironments()
+	if err != nil {
+		return fmt.Errorf("error getting environments - %v", err)
+	}
+	for _, e := range envAll {
+		if e.Name == env || e.UUID == env {
+			agent.Paths = e.Paths()
+		}
+	}
+	run := &smokeRun{}
+	var queryName, carveName string
+	// Node side
+	run.step("enroll", func() (string, error) {
+		if err := agent.Enroll(); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s enrolled as %s", agent.Hostname, agent.UUID), nil
+	})
+	run.step("config", func() (string, error) {
+		size, err := agent.Config()
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d bytes", size), nil
+	})
+	run.step("flags", func() (string, error) {
+		flags, err := agent.Flags()
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d flags", flags), nil
+	})
+	run.step("status log", func() (string, error) {
+		return "", agent.StatusLog("osctrl smoke test " + agent.UUID)
+	})
+	run.step("result log", func() (string, error) {
+		return "", agent.ResultLog(smokeResultName, []map[string]string{{"smoke": "1"}})
+	})
+	// On-demand query
+	run.step("query create", func() (string, error) {
+		r, err := osctrlAPI.RunQuery(env, types.ApiDistributedQueryRequest{
+			UUID:           agent.UUID,
+			Query:          smokeQuery,
+			Hidden:         true,
+			Description:    "osctrl smoke test",
+			ConfirmNotices: true,
+		})
+		if err != nil {
+			return "", err
+		}
+		queryName = r.Name
+		return queryName, nil
+	})
+	run.step("query read", func() (string, error) {
+		q, err := smokeWaitQuery(agent, queryName, timeout)
+		if err != nil {
+			return "", err
+		}
+		if q != smokeQuery {
+			return "", fmt.Errorf("unexpected query %q", q)
+		}
+		return "", nil
+	})
+	run.step("query write", func() (string, error) {
+		return "", agent.Write(queryName, []map[string]string{{"smoke": "1"}})
+	})
+	run.step("query results", func() (string, error) {
+		deadline := time.Now().Add(timeout)
+		for {
+			results, err := osctrlAPI.GetQueryResults(env, queryName)
+			if err != nil {
+				return "", fmt.Errorf("%v (results are only available with the DB logger)", err)
+			}
+			for uuid := range results {
+				if strings.EqualFold(uuid, agent.UUID) {
+					return "", nil
+				}
+			}
+			if time.Now().After(deadline) {
+				return "", fmt.Errorf("results not found after %s", timeout)
+			}
+			time.Sleep(smokePollInterval)
+		}
+	})
+	// File carve
+	if c.Bool("carve") {
+		path := c.String("carve-path")
+		run.step("carve create", func() (string, error) {
+			r, err := osctrlAPI.RunCarve(env, types.ApiDistributedCarveRequest{UUID: agent.UUID, Path: path, ConfirmNotices: true})
+			if err != nil {
+				return "", err
+			}
+			carveName = r.Name
+			return carveName, nil
+		})
+		run.step("carve blocks", func() (string, error) {
+			if _, err := smokeWaitQuery(agent, carveName, timeout); err != nil {
+				return "", err
+			}
+			return "", agent.Carve(carveName, path, []byte("osctrl smoke test "+agent.UUID+"\n"))
+		})
+		run.step("carve verify", func() (string, error) {
+			files, err := osctrlAPI.GetCarveFiles(env, carveName)
+			if err != nil {
+				return "", err
+			}
+			for _, f := range files {
+				if strings.EqualFold(f.UUID, agent.UUID) {
+					if f.Status != carves.StatusCompleted {
+						return "", fmt.Errorf("carve is %s with %d/%d blocks", f.Status, f.CompletedBlocks, f.TotalBlocks)
+					}
+					return fmt.Sprintf("%d bytes", f.CarveSize), nil
+				}
+			}
+			return "", fmt.Errorf("carve not found")
+		})
+	}
+	// Cleanup always runs, for whatever was created
+	if queryName != "" {
+		run.cleanup("cleanup query", func() (string, error) {
+			return "", osctrlAPI.DeleteQuery(env, queryName, true)
+		})
+	}
+	if carveName != "" {
+		run.cleanup("cleanup carve", func() (string, error) {
+			return "", osctrlAPI.DeleteQuery(env, carveName, true)
+		})
+	}
+	if agent.NodeKey != "" {
+		run.cleanup("cleanup node", func() (string, error) {
+			return "", osctrlAPI.DeleteNode(env, agent.UUID)
+		})
+	}
+	if err := printSmokeSteps(run.Steps); err != nil {
+		return err
+	}
+	for _, st := range run.Steps {
+		if st.Status == smokeFail {
+			os.Exit(1)
+		}
+	}
+	return nil
+}
+
+// Helper to print the outcome of the steps of the smoke test
+func printSmokeSteps(steps []smokeStep) error {
+	header := []string{
+		"Step",
+		"Status",
+		"Duration",
+		"Detail",
+	}
+	var data [][]string
+	for _, st := range steps {
+		data = append(data, []string{
+			st.Name,
+			st.Status,
+			st.Duration.Round(time.Millisecond).String(),
+			st.Detail,
+		})
+	}
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(steps)
+		if err != nil {
+			return fmt.Errorf("error json marshal - %s", err)
+		}
+		fmt.Println(string(jsonRaw))
+	} else if formatFlag == csvFormat {
+		w := csv.NewWriter(os.Stdout)
+		if err := w.WriteAll(append([][]string{header}, data...)); err != nil {
+			return fmt.Errorf("error csv writeall - %s", err)
+		}
+	} else if formatFlag == prettyFormat {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader(header)
+		table.AppendBulk(data)
+		table.Render()
+	}
+	return nil
+}
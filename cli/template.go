@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/jmpsec/osctrl/queries"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/olekukonko/tablewriter"
+	"github.com/urfave/cli/v2"
+)
+
+// Helper function to convert a slice of templates into the data expected for output
+func templatesToData(ts []queries.QueryTemplate, header []string) [][]string {
+	var data [][]string
+	if header != nil {
+		data = append(data, header)
+	}
+	for _, t := range ts {
+		data = append(data, templateToData(t, nil)...)
+	}
+	return data
+}
+
+func templateToData(t queries.QueryTemplate, header []string) [][]string {
+	var data [][]string
+	if header != nil {
+		data = append(data, header)
+	}
+	vars, _ := t.Vars()
+	var names []string
+	for _, v := range vars {
+		names = append(names, v.Name+":"+v.Type)
+	}
+	_t := []string{
+		t.Name,
+		strconv.Itoa(t.Version),
+		t.Description,
+		t.Query,
+		strings.Join(names, ", "),
+		t.Platforms,
+		t.Risk,
+		t.Team,
+	}
+	data = append(data, _t)
+	return data
+}
+
+// Helper function to convert a slice of template versions into the data expected for output
+func templateVersionsToData(vs []queries.QueryTemplateVersion, header []string) [][]string {
+	var data [][]string
+	if header != nil {
+		data = append(data, header)
+	}
+	for _, v := range vs {
+		data = append(data, []string{
+			strconv.Itoa(v.Version),
+			v.Author,
+			v.CreatedAt.Format("2006-01-02 15:04:05"),
+			v.Changelog,
+			v.Query,
+		})
+	}
+	return data
+}
+
+// Helper to parse the values of template variables from name=value
+func parseTemplateValues(values []string) (map[string]string, error) {
+	parsed := make(map[string]string)
+	for _, v := range values {
+		i := strings.Index(v, "=")
+		if i <= 0 {
+			return parsed, fmt.Errorf("invalid variable %q, use name=value", v)
+		}
+		parsed[v[:i]] = v[i+1:]
+	}
+	return parsed, nil
+}
+
+// Helper to get the template request from flags, for adding and updating templates
+func templateRequest(c *cli.Context) (types.ApiQueryTemplateRequest, error) {
+	tr := types.ApiQueryTemplateRequest{
+		Name:        c.String("name"),
+		Description: c.String("description"),
+		Query:       c.String("query"),
+		Risk:        c.String("risk"),
+		Team:        c.String("team"),
+		Approved:    c.Bool("approved"),
+		Changelog:   c.String("changelog"),
+	}
+	for _, spec := range c.StringSlice("var") {
+		v, err := queries.ParseTemplateVariable(spec)
+		if err != nil {
+			return tr, err
+		}
+		tr.Variables = append(tr.Variables, v)
+	}
+	for _, p := range strings.Split(c.String("platforms"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			tr.Platforms = append(tr.Platforms, p)
+		}
+	}
+	return tr, nil
+}
+
+// Helper to convert a template request into a template
+func requestToTemplate(tr types.ApiQueryTemplateRequest) queries.QueryTemplate {
+	return queries.QueryTemplate{
+		Name:        tr.Name,
+		Description: tr.Description,
+		Query:       tr.Query,
+		Variables:   queries.EncodeTemplateVariables(tr.Variables),
+		Platforms:   strings.Join(tr.Platforms, ","),
+		Risk:        tr.Risk,
+		Team:        tr.Team,
+		Approved:    tr.Approved,
+		Creator:     appName,
+	}
+}
+
+func listTemplates(c *cli.Context) error {
+	// Retrieve data
+	var ts []queries.QueryTemplate
+	if dbFlag {
+		ts, err = queriesmgr.GetTemplates()
+		if err != nil {
+			return fmt.Errorf("error get templates - %s", err)
+		}
+	} else if apiFlag {
+		ts, err = osctrlAPI.GetTemplates()
+		if err != nil {
+			return fmt.Errorf("error get templates - %s", err)
+		}
+	}
+	header := []string{
+		"Name",
+		"Version",
+		"Description",
+		"Query",
+		"Variables",
+		"Platforms",
+		"Risk",
+		"Team",
+	}
+	// Prepare output
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(ts)
+		if err != nil {
+			return fmt.Errorf("error json marshal - %s", err)
+		}
+		fmt.Println(string(jsonRaw))
+	} else if formatFlag == csvFormat {
+		data := templatesToData(ts, header)
+		w := csv.NewWriter(os.Stdout)
+		if err := w.WriteAll(data); err != nil {
+			return fmt.Errorf("error csv writeall - %s", err)
+		}
+	} else if formatFlag == prettyFormat {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader(header)
+		if len(ts) > 0 {
+			fmt.Printf("Existing templates (%d):\n", len(ts))
+			data := templatesToData(ts, nil)
+			table.AppendBulk(data)
+		} else {
+			fmt.Printf("No templates\n")
+		}
+		table.Render()
+	}
+	return nil
+}
+
+func addTemplate(c *cli.Context) error {
+	// Get values from flags
+	tr, err := templateRequest(c)
+	if err != nil {
+		fmt.Printf("❌ %s\n", err)
+		os.Exit(1)
+	}
+	if tr.Name == "" {
+		fmt.Println("❌ name is required")
+		os.Exit(1)
+	}
+	var t queries.QueryTemplate
+	if dbFlag {
+		if _, err := queriesmgr.GetTemplate(tr.Name); err == nil {
+			return fmt.Errorf("template %s already exists", tr.Name)
+		}
+		t, err = queriesmgr.CreateTemplate(requestToTemplate(tr), tr.Changelog)
+		if err != nil {
+			return fmt.Errorf("error create template - %s", err)
+		}
+	} else if apiFlag {
+		t, err = osctrlAPI.SaveTemplate(tr, false)
+		if err != nil {
+			return fmt.Errorf("error create template - %s", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ template %s version %d created successfully\n", t.Name, t.Version)
+	}
+	return nil
+}
+
+func updateTemplate(c *cli.Context) error {
+	// Get values from flags
+	tr, err := templateRequest(c)
+	if err != nil {
+		fmt.Printf("❌ %s\n", err)
+		os.Exit(1)
+	}
+	if tr.Name == "" {
+		fmt.Println("❌ name is required")
+		os.Exit(1)
+	}
+	if tr.Changelog == "" {
+		fmt.Println("❌ changelog is required")
+		os.Exit(1)
+	}
+	var t queries.QueryTemplate
+	if dbFlag {
+		t, err = queriesmgr.UpdateTemplate(tr.Name, requestToTemplate(tr), appName, tr.Changelog)
+		if err != nil {
+			return fmt.Errorf("error update template - %s", err)
+		}
+	} else if apiFlag {
+		t, err = osctrlAPI.SaveTemplate(tr, true)
+		if err != nil {
+			return fmt.Errorf("error update template - %s", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ template %s updated successfully to version %d\n", t.Name, t.Version)
+	}
+	return nil
+}
+
+func deleteTemplate(c *cli.Context) error {
+	// Get values from flags
+	name := c.String("name")
+	if name == "" {
+		fmt.Println("❌ name is required")
+		os.Exit(1)
+	}
+	if dbFlag {
+		var err error
+		if c.Bool("hard") {
+			err = queriesmgr.HardDeleteTemplate(name, appName)
+		} else {
+			err = queriesmgr.DeleteTemplate(name, appName)
+		}
+		if err != nil {
+			return fmt.Errorf("error delete template - %s", err)
+		}
+	} else if apiFlag {
+		if err := osctrlAPI.DeleteTemplate(name, c.Bool("hard")); err != nil {
+			return fmt.Errorf("error delete template - %s", err)
+		}
+	}
+	if !silentFlag {
+		if c.Bool("hard") {
+			fmt.Printf("✅ template %s deleted permanently\n", name)
+		} else {
+			fmt.Printf("✅ template %s moved to the trash\n", name)
+		}
+	}
+	return nil
+}
+
+func showTemplateVersions(c *cli.Context) error {
+	// Get values from flags
+	name := c.String("name")
+	if name == "" {
+		fmt.Println("❌ name is required")
+		os.Exit(1)
+	}
+	// Retrieve data
+	var vs []queries.QueryTemplateVersion
+	if dbFlag {
+		vs, err = queriesmgr.GetTemplateVersions(name)
+		if err != nil {
+			return fmt.Errorf("error get template versions - %s", err)
+		}
+	} else if apiFlag {
+		vs, err = osctrlAPI.GetTemplateVersions(name)
+		if err != nil {
+			return fmt.Errorf("error get template versions - %s", err)
+		}
+	}
+	header := []string{
+		"Version",
+		"Author",
+		"Date",
+		"Changelog",
+		"Query",
+	}
+	// Prepare output
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(vs)
+		if err != nil {
+			return fmt.Errorf("error json marshal - %s", err)
+		}
+		fmt.Println(string(jsonRaw))
+	} else if formatFlag == csvFormat {
+		data := templateVersionsToData(vs, header)
+		w := csv.NewWriter(os.Stdout)
+		if err := w.WriteAll(data); err != nil {
+			return fmt.Errorf("error csv writeall - %s", err)
+		}
+	} else if formatFlag == prettyFormat {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader(header)
+		if len(vs) > 0 {
+			fmt.Printf("Versions of template %s (%d):\n", name, len(vs))
+			data := templateVersionsToData(vs, nil)
+			table.AppendBulk(data)
+		} else {
+			fmt.Printf("No versions\n")
+		}
+		table.Render()
+	}
+	return nil
+}
@@ -156,6 +156,42 @@ func deleteUser(c *cli.Context) error {
 	return nil
 }
 
+func unlockUser(c *cli.Context) error {
+	// Get values from flags
+	username := c.String("username")
+	ipaddress := c.String("ip")
+	if username == "" && ipaddress == "" {
+		fmt.Println("❌ username or IP address is required")
+		os.Exit(1)
+	}
+	var msg string
+	if dbFlag {
+		var unlocked int64
+		subjects := map[string]string{users.LoginUser: username, users.LoginIP: ipaddress}
+		for kind, subject := range subjects {
+			if subject == "" {
+				continue
+			}
+			n, err := adminUsers.UnlockLogin(kind, subject, appName)
+			if err != nil {
+				return fmt.Errorf("error unlocking - %s", err)
+			}
+			unlocked += n
+		}
+		msg = fmt.Sprintf("%d lockouts unlocked", unlocked)
+	} else if apiFlag {
+		r, err := osctrlAPI.UnlockUser(username, ipaddress)
+		if err != nil {
+			return fmt.Errorf("error unlocking - %s", err)
+		}
+		msg = r.Message
+	}
+	if !silentFlag {
+		fmt.Printf("✅ %s\n", msg)
+	}
+	return nil
+}
+
 func listUsers(c *cli.Context) error {
 	// Retrieve data
 	var usrs []users.AdminUser
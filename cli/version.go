@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/jmpsec/osctrl/version"
+	"github.com/olekukonko/tablewriter"
+	"github.com/urfave/cli/v2"
+)
+
+// Helper to get the URLs of the remote services to compare with the CLI, by service name
+func versionRemotes(c *cli.Context, config JSONConfigurationAPI) [][2]string {
+	remotes := [][2]string{{projectName + "-api", config.URL}}
+	if c.String("admin-url") != "" {
+		remotes = append(remotes, [2]string{projectName + "-admin", c.String("admin-url")})
+	}
+	if c.String("tls-url") != "" {
+		remotes = append(remotes, [2]string{projectName + "-tls", c.String("tls-url")})
+	}
+	return remotes
+}
+
+// Helper to check if a build matches the build of the CLI
+func versionMatches(local, remote version.BuildInfo) bool {
+	return local.Version == remote.Version && local.Commit == remote.Commit && local.SchemaVersion == remote.SchemaVersion
+}
+
+func showVersion(c *cli.Context) error {
+	if !formats[formatFlag] {
+		return fmt.Errorf("invalid format %s", formatFlag)
+	}
+	local := version.Info(appName)
+	builds := []version.BuildInfo{local}
+	if c.Bool("remote") {
+		config := apiConfig
+		if config.URL == "" && apiConfigFile != "" {
+			if config, err = loadAPIConfiguration(apiConfigFile); err != nil {
+				return fmt.Errorf("loadAPIConfiguration - %v", err)
+			}
+		}
+		if config.URL == "" {
+			return fmt.Errorf("API URL is required for remote versions")
+		}
+		api := CreateAPI(config, insecureFlag)
+		for _, remote := range versionRemotes(c, config) {
+			info, err := api.GetVersion(remote[1])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "❌ error getting version of %s - %s\n", remote[0], err)
+				info = version.BuildInfo{Service: remote[0], Version: version.Unknown, Commit: version.Unknown}
+			}
+			builds = append(builds, info)
+		}
+	}
+	header := []string{
+		"Service",
+		"Version",
+		"Commit",
+		"Build date",
+		"Go",
+		"Schema",
+		"Matches CLI",
+	}
+	data := [][]string{}
+	for _, b := range builds {
+		matches := "✅"
+		if !versionMatches(local, b) {
+			matches = "❌"
+		}
+		data = append(data, []string{
+			b.Service,
+			b.Version,
+			b.ShortCommit(),
+			b.BuildDate,
+			b.GoVersion,
+			strconv.Itoa(b.SchemaVersion),
+			matches,
+		})
+	}
+	// Prepare output
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(builds)
+		if err != nil {
+			return fmt.Errorf("error marshaling - %s", err)
+		}
+		fmt.Println(string(jsonRaw))
+	} else if formatFlag == csvFormat {
+		w := csv.NewWriter(os.Stdout)
+		if err := w.WriteAll(append([][]string{header}, data...)); err != nil {
+			return fmt.Errorf("error writting csv - %s", err)
+		}
+	} else if formatFlag == prettyFormat {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader(header)
+		table.AppendBulk(data)
+		table.Render()
+	}
+	return nil
+}
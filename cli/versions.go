@@ -0,0 +1,396 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/olekukonko/tablewriter"
+	"github.com/urfave/cli/v2"
+)
+
+// Helper to get the configuration versions of an environment, newest first
+func getConfigVersions(envName string) ([]environments.ConfigVersion, error) {
+	if apiFlag {
+		return osctrlAPI.GetConfigVersions(envName)
+	}
+	env, err := envs.Get(envName)
+	if err != nil {
+		return nil, fmt.Errorf("error getting environment - %s", err)
+	}
+	return envs.ConfigVersions(env.ID)
+}
+
+func configHistory(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ Environment name is required")
+		os.Exit(1)
+	}
+	versions, err := getConfigVersions(envName)
+	if err != nil {
+		return fmt.Errorf("error getting versions - %s", err)
+	}
+	header := []string{
+		"Version",
+		"Author",
+		"Created",
+		"Note",
+	}
+	data := [][]string{}
+	for _, v := range versions {
+		data = append(data, []string{
+			strconv.Itoa(v.Version),
+			v.Author,
+			v.CreatedAt.Format("2006-01-02 15:04:05"),
+			v.Note,
+		})
+	}
+	// Prepare output
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(versions)
+		if err != nil {
+			return fmt.Errorf("error marshaling - %s", err)
+		}
+		fmt.Println(string(jsonRaw))
+	} else if formatFlag == csvFormat {
+		w := csv.NewWriter(os.Stdout)
+		if err := w.WriteAll(append([][]string{header}, data...)); err != nil {
+			return fmt.Errorf("error writting csv - %s", err)
+		}
+	} else if formatFlag == prettyFormat {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader(header)
+		if len(versions) > 0 {
+			fmt.Printf("Configuration versions in %s (%d):\n", envName, len(versions))
+			table.AppendBulk(data)
+		} else {
+			fmt.Printf("No configuration versions in %s\n", envName)
+		}
+		table.Render()
+	}
+	return nil
+}
+
+func configDiff(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ Environment name is required")
+		os.Exit(1)
+	}
+	versions, err := getConfigVersions(envName)
+	if err != nil {
+		return fmt.Errorf("error getting versions - %s", err)
+	}
+	if len(versions) == 0 {
+		return fmt.Errorf("no configuration versions in %s", envName)
+	}
+	// Missing versions compare the latest with the one before
+	to, from := versions[0], versions[0]
+	if len(versions) > 1 {
+		from = versions[1]
+	}
+	for _, v := range versions {
+		if v.Version == c.Int("to") {
+			to = v
+		}
+		if v.Version == c.Int("from") {
+			from = v
+		}
+	}
+	diffs := environments.DiffVersions(from, to)
+	// Prepare output
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(diffs)
+		if err != nil {
+			return fmt.Errorf("error marshaling - %s", err)
+		}
+		fmt.Println(string(jsonRaw))
+	} else if formatFlag == csvFormat {
+		data := [][]string{{"Section", "Version " + strconv.Itoa(from.Version), "Version " + strconv.Itoa(to.Version)}}
+		for _, d := range diffs {
+			for _, r := range d.Rows {
+				data = append(data, []string{d.Section, r.Left.Op + r.Left.Text, r.Right.Op + r.Right.Text})
+			}
+		}
+		w := csv.NewWriter(os.Stdout)
+		if err := w.WriteAll(data); err != nil {
+			return fmt.Errorf("error writting csv - %s", err)
+		}
+	} else if formatFlag == prettyFormat {
+		fmt.Printf("Configuration of %s from version %d to version %d:\n", envName, from.Version, to.Version)
+		for _, d := range diffs {
+			if !d.Changed {
+				fmt.Printf("%s: unchanged\n", d.Section)
+				continue
+			}
+			table := tablewriter.NewWriter(os.Stdout)
+			table.SetHeader([]string{d.Section + " v" + strconv.Itoa(from.Version), d.Section + " v" + strconv.Itoa(to.Version)})
+			table.SetAutoWrapText(false)
+			for _, r := range d.Rows {
+				table.Append([]string{r.Left.Op + " " + r.Left.Text, r.Right.Op + " " + r.Right.Text})
+			}
+			table.Render()
+		}
+	}
+	return nil
+}
+
+func configRollback(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ Environment name is required")
+		os.Exit(1)
+	}
+	version := c.Int("version")
+	if version == 0 {
+		fmt.Println("❌ Version is required")
+		os.Exit(1)
+	}
+	var v environments.ConfigVersion
+	if dbFlag {
+		v, err = envs.RollbackConfig(envName, version, appName, c.String("note"))
+		if err != nil {
+			return fmt.Errorf("error rolling back - %s", err)
+		}
+	} else if apiFlag {
+		v, err = osctrlAPI.RollbackConfig(envName, version, c.String("note"))
+		if err != nil {
+			return fmt.Errorf("error rolling back - %s", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ Configuration of %s rolled back to version %d as version %d\n", envName, version, v.Version)
+	}
+	return nil
+}
+
+func configApplyAt(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ Environment name is required")
+		os.Exit(1)
+	}
+	section := c.String("section")
+	if !environments.IsConfigKind(section) {
+		fmt.Printf("❌ Section must be one of %s\n", strings.Join(environments.ConfigSections, ", "))
+		os.Exit(1)
+	}
+	input := c.String("input")
+	if input == "" {
+		fmt.Println("❌ Input file is required")
+		os.Exit(1)
+	}
+	applyAt, err := time.Parse(time.RFC3339, c.String("at"))
+	if err != nil {
+		fmt.Println("❌ Apply time is required in RFC3339, like 2006-01-02T15:04:05Z")
+		os.Exit(1)
+	}
+	raw, err := os.ReadFile(input)
+	if err != nil {
+		return fmt.Errorf("error reading %s - %s", input, err)
+	}
+	var change environments.ScheduledChange
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		change, err = envs.ScheduleConfigChange(env, section, string(raw), applyAt, appName, c.String("note"), c.Bool("replace"), time.Now())
+		if err != nil {
+			return fmt.Errorf("error scheduling change - %s", err)
+		}
+	} else if apiFlag {
+		change, err = osctrlAPI.ScheduleConfig(envName, types.ApiScheduleConfigRequest{
+			Section: section,
+			Value:   string(raw),
+			ApplyAt: applyAt,
+			Note:    c.String("note"),
+			Replace: c.Bool("replace"),
+		})
+		if err != nil {
+			return fmt.Errorf("error scheduling change - %s", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ %s change of %s scheduled for %s\n", change.Section, envName, change.ApplyAt.UTC().Format(environments.ScheduledTimeFormat))
+	}
+	return nil
+}
+
+func configCancelScheduled(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ Environment name is required")
+		os.Exit(1)
+	}
+	var change environments.ScheduledChange
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		change, err = envs.CancelConfigChange(env.ID, appName, time.Now())
+		if err != nil {
+			return fmt.Errorf("error cancelling change - %s", err)
+		}
+	} else if apiFlag {
+		var err error
+		change, err = osctrlAPI.CancelScheduledConfig(envName)
+		if err != nil {
+			return fmt.Errorf("error cancelling change - %s", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ %s change of %s scheduled for %s cancelled\n", change.Section, envName, change.ApplyAt.UTC().Format(environments.ScheduledTimeFormat))
+	}
+	return nil
+}
+
+// Helper to verify the flags and configuration of an environment from the database, like the API does
+func verifyConfigDB(envName, section, value, platform, version, profileName string) (environments.VerifyReport, error) {
+	env, err := envs.Get(envName)
+	if err != nil {
+		return environments.VerifyReport{}, fmt.Errorf("error getting environment - %s", err)
+	}
+	if section != "" {
+		if env, err = envs.ProposedServed(env, section, value); err != nil {
+			return environments.VerifyReport{}, fmt.Errorf("invalid configuration - %s", err)
+		}
+	}
+	var profile environments.PerformanceProfile
+	if profileName != "" {
+		profiles, err := envs.Profiles(env.ID)
+		if err != nil {
+			return environments.VerifyReport{}, fmt.Errorf("error getting profiles - %s", err)
+		}
+		for _, p := range profiles {
+			if p.Name == profileName {
+				profile = p
+			}
+		}
+		if profile.Name == "" {
+			return environments.VerifyReport{}, fmt.Errorf("profile %s not found", profileName)
+		}
+	}
+	report, err := nodesmgr.GetVersionReport(env.ID, func(string) string { return "" })
+	if err != nil {
+		return environments.VerifyReport{}, fmt.Errorf("error getting osquery versions - %s", err)
+	}
+	var fleet []environments.VerifyTarget
+	for _, v := range report.Versions {
+		fleet = append(fleet, environments.VerifyTarget{Platform: v.Platform, Version: v.Version})
+	}
+	channels, err := envs.WindowsEvents(env.ID)
+	if err != nil {
+		return environments.VerifyReport{}, fmt.Errorf("error getting windows event channels - %s", err)
+	}
+	// The osqueryd check only runs on the admin host and in the API
+	targets := environments.SelectTargets(env, fleet, platform, version)
+	return envs.VerifyServed(context.Background(), env, profile, channels, targets, nil)
+}
+
+func configVerify(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ Environment name is required")
+		os.Exit(1)
+	}
+	version := c.String("version")
+	if version != "" && !environments.ValidOsqueryVersion(version) {
+		fmt.Println("❌ Version must be like 5.9.1")
+		os.Exit(1)
+	}
+	// A change of one section can be verified before saving it
+	section := c.String("section")
+	var value string
+	if section != "" {
+		if !environments.IsConfigKind(section) {
+			fmt.Printf("❌ Section must be one of %s\n", strings.Join(environments.ConfigSections, ", "))
+			os.Exit(1)
+		}
+		raw, err := os.ReadFile(c.String("input"))
+		if err != nil {
+			return fmt.Errorf("error reading %s - %s", c.String("input"), err)
+		}
+		value = string(raw)
+	}
+	var report environments.VerifyReport
+	var err error
+	if dbFlag {
+		report, err = verifyConfigDB(envName, section, value, c.String("platform"), version, c.String("profile"))
+	} else if apiFlag {
+		filter := url.Values{}
+		for _, f := range []string{"version", "platform", "profile"} {
+			if c.String(f) != "" {
+				filter.Set(f, c.String(f))
+			}
+		}
+		report, err = osctrlAPI.VerifyConfig(envName, filter, types.ApiConfigImpactRequest{Section: section, Value: value})
+	}
+	if err != nil {
+		return fmt.Errorf("error verifying configuration - %s", err)
+	}
+	header := []string{
+		"Version",
+		"Platform",
+		"Severity",
+		"Source",
+		"Name",
+		"Line",
+		"Message",
+	}
+	data := [][]string{}
+	for _, res := range report.Results {
+		for _, i := range res.Issues {
+			data = append(data, []string{res.Version, res.Platform, i.Severity, i.Source, i.Name, strconv.Itoa(i.Line), i.Message})
+		}
+	}
+	for _, check := range report.Osqueryd {
+		for _, i := range check.Issues {
+			data = append(data, []string{check.Version, check.Platform, i.Severity, i.Source, i.Name, "", i.Message})
+		}
+	}
+	// Prepare output
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(report)
+		if err != nil {
+			return fmt.Errorf("error marshaling - %s", err)
+		}
+		fmt.Println(string(jsonRaw))
+	} else if formatFlag == csvFormat {
+		w := csv.NewWriter(os.Stdout)
+		if err := w.WriteAll(append([][]string{header}, data...)); err != nil {
+			return fmt.Errorf("error writting csv - %s", err)
+		}
+	} else if formatFlag == prettyFormat {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader(header)
+		if len(data) > 0 {
+			fmt.Printf("Issues of the configuration of %s (%d):\n", envName, len(data))
+			table.AppendBulk(data)
+		} else {
+			fmt.Printf("No issues in the configuration of %s for %d targets\n", envName, len(report.Results))
+		}
+		table.Render()
+	}
+	// Failures exit with an error, to gate changes in CI
+	if !report.OK {
+		return fmt.Errorf("configuration of %s not accepted by all the osquery versions", envName)
+	}
+	return nil
+}
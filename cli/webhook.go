@@ -0,0 +1,347 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/webhooks"
+	"github.com/urfave/cli/v2"
+)
+
+// Helper to get the endpoint name from flags, exiting when it is missing
+func webhookName(c *cli.Context) string {
+	name := c.String("name")
+	if name == "" {
+		fmt.Println("❌ Endpoint name is required")
+		os.Exit(1)
+	}
+	return name
+}
+
+// Helper to print the secret of an endpoint, it is only shown when it is generated
+func printWebhookSecret(name, secret, envName, msg string) {
+	if silentFlag {
+		return
+	}
+	fmt.Printf("✅ Webhook endpoint %s %s in %s\n", name, msg, envName)
+	fmt.Printf("Secret to verify the %s of deliveries, it is not shown again:\n%s\n", webhooks.HeaderSignature, secret)
+}
+
+func listWebhooks(c *cli.Context) error {
+	envName := responsesEnv(c)
+	var endpoints []webhooks.WebhookEndpoint
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		endpoints, err = webhooksmgr.Endpoints(env.ID)
+		if err != nil {
+			return fmt.Errorf("error getting webhook endpoints - %s", err)
+		}
+	} else if apiFlag {
+		endpoints, err = osctrlAPI.GetWebhooks(envName)
+		if err != nil {
+			return fmt.Errorf("error getting webhook endpoints - %s", err)
+		}
+	}
+	header := []string{
+		"Name",
+		"URL",
+		"Events",
+		"Enabled",
+		"Creator",
+		"Secret rotated",
+	}
+	data := [][]string{}
+	for _, e := range endpoints {
+		events := e.Events
+		if events == "" {
+			events = "all"
+		}
+		data = append(data, []string{
+			e.Name,
+			e.URL,
+			events,
+			stringifyBool(e.Enabled),
+			e.Creator,
+			e.RotatedAt.Format(time.RFC3339),
+		})
+	}
+	return printResponsesData(endpoints, header, data, fmt.Sprintf("Webhook endpoints in %s (%d):", envName, len(endpoints)), "No webhook endpoints in "+envName)
+}
+
+func createWebhook(c *cli.Context) error {
+	envName := responsesEnv(c)
+	name := webhookName(c)
+	req := types.ApiWebhookRequest{
+		Name:   name,
+		URL:    c.String("url"),
+		Events: c.StringSlice("event"),
+	}
+	var secret string
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		e, err := webhooksmgr.CreateEndpoint(env.ID, webhooks.WebhookEndpoint{
+			Name:    req.Name,
+			URL:     req.URL,
+			Events:  strings.Join(req.Events, ","),
+			Creator: appName,
+		})
+		if err != nil {
+			return fmt.Errorf("error creating webhook endpoint - %s", err)
+		}
+		if err := envs.RecordActivity(env.ID, environments.ActivityWebhooks, appName, "webhook endpoint "+e.Name+" created", "", webhooks.SerializeEndpoints([]webhooks.WebhookEndpoint{e})); err != nil {
+			log.Printf("error recording activity - %s", err)
+		}
+		secret = e.Secret
+	} else if apiFlag {
+		s, err := osctrlAPI.CreateWebhook(envName, req)
+		if err != nil {
+			return fmt.Errorf("error creating webhook endpoint - %s", err)
+		}
+		secret = s.Secret
+	}
+	printWebhookSecret(name, secret, envName, "created")
+	return nil
+}
+
+func rotateWebhook(c *cli.Context) error {
+	envName := responsesEnv(c)
+	name := webhookName(c)
+	var secret string
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		e, err := webhooksmgr.RotateSecret(env.ID, name)
+		if err != nil {
+			return fmt.Errorf("error rotating secret - %s", err)
+		}
+		if err := envs.RecordActivity(env.ID, environments.ActivityWebhooks, appName, "secret of webhook endpoint "+e.Name+" rotated", "", ""); err != nil {
+			log.Printf("error recording activity - %s", err)
+		}
+		secret = e.Secret
+	} else if apiFlag {
+		s, err := osctrlAPI.RotateWebhook(envName, name)
+		if err != nil {
+			return fmt.Errorf("error rotating secret - %s", err)
+		}
+		secret = s.Secret
+	}
+	printWebhookSecret(name, secret, envName, "rotated")
+	return nil
+}
+
+// Helper to enable, disable or delete an endpoint
+func webhookAction(c *cli.Context, action string) error {
+	envName := responsesEnv(c)
+	name := webhookName(c)
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		if action == "delete" {
+			err = webhooksmgr.DeleteEndpoint(env.ID, name)
+		} else {
+			_, err = webhooksmgr.SetEnabled(env.ID, name, action == "enable")
+		}
+		if err != nil {
+			return fmt.Errorf("error changing webhook endpoint - %s", err)
+		}
+		if err := envs.RecordActivity(env.ID, environments.ActivityWebhooks, appName, "webhook endpoint "+name+" "+action+"d", "", ""); err != nil {
+			log.Printf("error recording activity - %s", err)
+		}
+	} else if apiFlag {
+		if _, err := osctrlAPI.WebhookAction(envName, name, action); err != nil {
+			return fmt.Errorf("error changing webhook endpoint - %s", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ Webhook endpoint %s %sd in %s\n", name, action, envName)
+	}
+	return nil
+}
+
+func enableWebhook(c *cli.Context) error {
+	return webhookAction(c, "enable")
+}
+
+func disableWebhook(c *cli.Context) error {
+	return webhookAction(c, "disable")
+}
+
+func deleteWebhook(c *cli.Context) error {
+	return webhookAction(c, "delete")
+}
+
+func testWebhook(c *cli.Context) error {
+	envName := responsesEnv(c)
+	name := webhookName(c)
+	var d webhooks.WebhookDelivery
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		d, err = webhooksmgr.TestFire(env.ID, name, appName)
+		if err != nil {
+			return fmt.Errorf("error sending test event - %s", err)
+		}
+	} else if apiFlag {
+		d, err = osctrlAPI.TestWebhook(envName, name)
+		if err != nil {
+			return fmt.Errorf("error sending test event - %s", err)
+		}
+	}
+	if d.State != webhooks.StateDelivered {
+		return fmt.Errorf("test event not delivered (%d) - %s", d.StatusCode, d.Error)
+	}
+	if !silentFlag {
+		fmt.Printf("✅ Test event delivered to %s (%d)\n", name, d.StatusCode)
+	}
+	return nil
+}
+
+func listWebhookDeliveries(c *cli.Context) error {
+	envName := responsesEnv(c)
+	name := webhookName(c)
+	state := c.String("state")
+	var deliveries []webhooks.WebhookDelivery
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		e, err := webhooksmgr.GetEndpoint(env.ID, name)
+		if err != nil {
+			return fmt.Errorf("error getting webhook endpoint - %s", err)
+		}
+		deliveries, err = webhooksmgr.Deliveries(e.ID, state, c.Int("limit"))
+		if err != nil {
+			return fmt.Errorf("error getting webhook deliveries - %s", err)
+		}
+	} else if apiFlag {
+		deliveries, err = osctrlAPI.GetWebhookDeliveries(envName, name, state, c.Int("limit"))
+		if err != nil {
+			return fmt.Errorf("error getting webhook deliveries - %s", err)
+		}
+	}
+	header := []string{
+		"ID",
+		"Created",
+		"Event",
+		"Entity",
+		"State",
+		"Attempts",
+		"Response",
+		"Error",
+	}
+	data := [][]string{}
+	for _, d := range deliveries {
+		data = append(data, []string{
+			strconv.FormatUint(uint64(d.ID), 10),
+			d.CreatedAt.Format(time.RFC3339),
+			d.EventType,
+			d.EntityType + " " + d.EntityID,
+			d.State,
+			strconv.Itoa(d.Attempts),
+			strconv.Itoa(d.StatusCode),
+			d.Error,
+		})
+	}
+	return printResponsesData(deliveries, header, data, fmt.Sprintf("Deliveries of %s (%d):", name, len(deliveries)), "No deliveries of "+name)
+}
+
+func redeliverWebhook(c *cli.Context) error {
+	envName := responsesEnv(c)
+	name := webhookName(c)
+	id := c.Uint("id")
+	if id == 0 {
+		fmt.Println("❌ Delivery id is required")
+		os.Exit(1)
+	}
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		e, err := webhooksmgr.GetEndpoint(env.ID, name)
+		if err != nil {
+			return fmt.Errorf("error getting webhook endpoint - %s", err)
+		}
+		if _, err := webhooksmgr.Redeliver(e.ID, id); err != nil {
+			return fmt.Errorf("error redelivering - %s", err)
+		}
+		if err := envs.RecordActivity(env.ID, environments.ActivityWebhooks, appName, fmt.Sprintf("delivery %d of webhook endpoint %s queued again", id, name), "", ""); err != nil {
+			log.Printf("error recording activity - %s", err)
+		}
+	} else if apiFlag {
+		if _, err := osctrlAPI.RedeliverWebhook(envName, name, id); err != nil {
+			return fmt.Errorf("error redelivering - %s", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ Delivery %d of %s queued again\n", id, name)
+	}
+	return nil
+}
+
+func webhookHistory(c *cli.Context) error {
+	envName := responsesEnv(c)
+	entity := c.String("entity")
+	id := c.String("id")
+	if id == "" {
+		fmt.Println("❌ Entity id is required")
+		os.Exit(1)
+	}
+	var attempts []webhooks.WebhookAttempt
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		attempts, err = webhooksmgr.EntityAttempts(env.ID, entity, id)
+		if err != nil {
+			return fmt.Errorf("error getting webhook attempts - %s", err)
+		}
+	} else if apiFlag {
+		attempts, err = osctrlAPI.GetWebhookHistory(envName, entity, id)
+		if err != nil {
+			return fmt.Errorf("error getting webhook attempts - %s", err)
+		}
+	}
+	header := []string{
+		"Created",
+		"Endpoint",
+		"Event",
+		"Attempt",
+		"Response",
+		"Duration",
+		"Error",
+	}
+	data := [][]string{}
+	for _, a := range attempts {
+		data = append(data, []string{
+			a.CreatedAt.Format(time.RFC3339),
+			a.Endpoint,
+			a.EventType + " " + a.EventID,
+			strconv.Itoa(a.Attempt),
+			strconv.Itoa(a.StatusCode),
+			fmt.Sprintf("%d ms", a.Duration),
+			a.Error,
+		})
+	}
+	return printResponsesData(attempts, header, data, fmt.Sprintf("Webhook attempts for %s %s (%d):", entity, id, len(attempts)), fmt.Sprintf("No webhook attempts for %s %s", entity, id))
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/olekukonko/tablewriter"
+	"github.com/urfave/cli/v2"
+)
+
+// Helper to show the warnings of Windows event channels
+func printWindowsEventsWarnings(warnings []string) {
+	for _, w := range warnings {
+		fmt.Printf("⚠️  %s\n", w)
+	}
+}
+
+// Helper to save the Windows event channels of an environment in the DB, recording the activity with the changes
+func saveWindowsEvents(envName, summary string, save func(env environments.TLSEnvironment) error) (environments.WindowsEventsPreview, error) {
+	var preview environments.WindowsEventsPreview
+	env, err := envs.Get(envName)
+	if err != nil {
+		return preview, fmt.Errorf("error getting environment - %s", err)
+	}
+	before, err := envs.WindowsEvents(env.ID)
+	if err != nil {
+		return preview, fmt.Errorf("error getting windows event channels - %s", err)
+	}
+	if err := save(env); err != nil {
+		return preview, err
+	}
+	after, err := envs.WindowsEvents(env.ID)
+	if err != nil {
+		return preview, fmt.Errorf("error getting windows event channels - %s", err)
+	}
+	if err := envs.RecordActivity(env.ID, environments.ActivityWindowsEvents, appName, summary, environments.SerializeWindowsEvents(before), environments.SerializeWindowsEvents(after)); err != nil {
+		log.Printf("error recording activity - %s", err)
+	}
+	return environments.PreviewWindowsEvents(after), nil
+}
+
+func listWindowsEvents(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ Environment name is required")
+		os.Exit(1)
+	}
+	var preview environments.WindowsEventsPreview
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		channels, err := envs.WindowsEvents(env.ID)
+		if err != nil {
+			return fmt.Errorf("error getting windows event channels - %s", err)
+		}
+		preview = environments.PreviewWindowsEvents(channels)
+	} else if apiFlag {
+		preview, err = osctrlAPI.GetWindowsEvents(envName)
+		if err != nil {
+			return fmt.Errorf("error getting windows event channels - %s", err)
+		}
+	}
+	header := []string{
+		"Channel",
+		"Enabled",
+		"Interval",
+		"Max Rows",
+		"Query",
+	}
+	data := [][]string{}
+	for _, ch := range preview.Channels {
+		query := ""
+		if ch.Enabled {
+			query = environments.WindowsEventsQueryName(ch.Channel)
+		}
+		data = append(data, []string{
+			ch.Channel,
+			stringifyBool(ch.Enabled),
+			strconv.Itoa(ch.Interval),
+			strconv.Itoa(ch.MaxRows),
+			query,
+		})
+	}
+	// Prepare output
+	if formatFlag == jsonFormat {
+		jsonRaw, err := json.Marshal(preview)
+		if err != nil {
+			return fmt.Errorf("error marshaling - %s", err)
+		}
+		fmt.Println(string(jsonRaw))
+	} else if formatFlag == csvFormat {
+		w := csv.NewWriter(os.Stdout)
+		if err := w.WriteAll(append([][]string{header}, data...)); err != nil {
+			return fmt.Errorf("error writting csv - %s", err)
+		}
+	} else if formatFlag == prettyFormat {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader(header)
+		if len(preview.Channels) > 0 {
+			fmt.Printf("Existing windows event channels in %s (%d):\n", envName, len(preview.Channels))
+			table.AppendBulk(data)
+		} else {
+			fmt.Printf("No windows event channels in %s\n", envName)
+		}
+		table.Render()
+		if preview.Flags != "" {
+			fmt.Printf("Flags for windows nodes:\n%s\n", preview.Flags)
+		}
+		printWindowsEventsWarnings(preview.Warnings)
+	}
+	return nil
+}
+
+func setWindowsEvent(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ Environment name is required")
+		os.Exit(1)
+	}
+	channel := c.String("channel")
+	if channel == "" {
+		fmt.Println("❌ Channel is required")
+		os.Exit(1)
+	}
+	ch := types.ApiWindowsEventRequest{
+		Channel:  channel,
+		Enabled:  !c.Bool("disable"),
+		Interval: c.Int("interval"),
+		MaxRows:  c.Int("max-rows"),
+	}
+	var preview environments.WindowsEventsPreview
+	if dbFlag {
+		preview, err = saveWindowsEvents(envName, "windows event channel "+channel+" saved", func(env environments.TLSEnvironment) error {
+			_, err := envs.SetWindowsEventChannel(env.ID, environments.WindowsEventChannel{
+				Channel:  ch.Channel,
+				Enabled:  ch.Enabled,
+				Interval: ch.Interval,
+				MaxRows:  ch.MaxRows,
+			})
+			if err != nil {
+				return fmt.Errorf("error saving windows event channel - %s", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	} else if apiFlag {
+		preview, err = osctrlAPI.SetWindowsEvent(envName, ch)
+		if err != nil {
+			return fmt.Errorf("error saving windows event channel - %s", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ Windows event channel %s saved in %s\n", channel, envName)
+		printWindowsEventsWarnings(preview.Warnings)
+	}
+	return nil
+}
+
+func deleteWindowsEvent(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ Environment name is required")
+		os.Exit(1)
+	}
+	channel := c.String("channel")
+	if channel == "" {
+		fmt.Println("❌ Channel is required")
+		os.Exit(1)
+	}
+	if dbFlag {
+		_, err = saveWindowsEvents(envName, "windows event channel "+channel+" deleted", func(env environments.TLSEnvironment) error {
+			if err := envs.DeleteWindowsEventChannel(env.ID, channel); err != nil {
+				return fmt.Errorf("error deleting windows event channel - %s", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	} else if apiFlag {
+		if _, err := osctrlAPI.DeleteWindowsEvent(envName, channel); err != nil {
+			return fmt.Errorf("error deleting windows event channel - %s", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ Windows event channel %s deleted from %s\n", channel, envName)
+	}
+	return nil
+}
+
+func exportWindowsEvents(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ Environment name is required")
+		os.Exit(1)
+	}
+	var settings []environments.WindowsEventSetting
+	if dbFlag {
+		env, err := envs.Get(envName)
+		if err != nil {
+			return fmt.Errorf("error getting environment - %s", err)
+		}
+		channels, err := envs.WindowsEvents(env.ID)
+		if err != nil {
+			return fmt.Errorf("error getting windows event channels - %s", err)
+		}
+		settings = environments.ExportWindowsEvents(channels)
+	} else if apiFlag {
+		settings, err = osctrlAPI.ExportWindowsEvents(envName)
+		if err != nil {
+			return fmt.Errorf("error exporting windows event channels - %s", err)
+		}
+	}
+	sort.SliceStable(settings, func(i, j int) bool {
+		return settings[i].Channel < settings[j].Channel
+	})
+	jsonRaw, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling - %s", err)
+	}
+	output := c.String("output")
+	if output == "" {
+		fmt.Println(string(jsonRaw))
+		return nil
+	}
+	if err := os.WriteFile(output, jsonRaw, 0644); err != nil {
+		return fmt.Errorf("error writing %s - %s", output, err)
+	}
+	if !silentFlag {
+		fmt.Printf("✅ %d windows event channels of %s exported to %s\n", len(settings), envName, output)
+	}
+	return nil
+}
+
+func importWindowsEvents(c *cli.Context) error {
+	// Get environment name
+	envName := c.String("name")
+	if envName == "" {
+		fmt.Println("❌ Environment name is required")
+		os.Exit(1)
+	}
+	input := c.String("input")
+	if input == "" {
+		fmt.Println("❌ Input file is required")
+		os.Exit(1)
+	}
+	raw, err := os.ReadFile(input)
+	if err != nil {
+		return fmt.Errorf("error reading %s - %s", input, err)
+	}
+	var settings []environments.WindowsEventSetting
+	if err := json.Unmarshal(raw, &settings); err != nil {
+		return fmt.Errorf("error parsing %s - %s", input, err)
+	}
+	var preview environments.WindowsEventsPreview
+	summary := fmt.Sprintf("%d windows event channels imported", len(settings))
+	if dbFlag {
+		preview, err = saveWindowsEvents(envName, summary, func(env environments.TLSEnvironment) error {
+			if _, err := envs.ImportWindowsEvents(env.ID, settings); err != nil {
+				return fmt.Errorf("error importing windows event channels - %s", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	} else if apiFlag {
+		preview, err = osctrlAPI.ImportWindowsEvents(envName, settings)
+		if err != nil {
+			return fmt.Errorf("error importing windows event channels - %s", err)
+		}
+	}
+	if !silentFlag {
+		fmt.Printf("✅ %d windows event channels imported in %s\n", len(settings), envName)
+		printWindowsEventsWarnings(preview.Warnings)
+	}
+	return nil
+}
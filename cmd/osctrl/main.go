@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/version"
+	"github.com/urfave/cli/v2"
+	adminserver "osctrl/admin/server"
+	apiserver "osctrl/api/server"
+	"osctrl/service"
+	tlsserver "osctrl/tls/server"
+)
+
+const (
+	// Project name
+	projectName string = "osctrl"
+	// Service version
+	serviceVersion string = version.OsctrlVersion
+	// Service description
+	serviceDescription string = "osctrl-tls, osctrl-admin and osctrl-api in one process"
+	// Application description
+	appDescription string = serviceDescription + ", for small deployments"
+	// Default configuration folder
+	defConfigurationDir string = "config"
+	// Time to write what is pending in the shared managers after all services stopped
+	shutdownTimeout = 30 * time.Second
+)
+
+// Variables for flags
+var (
+	allInOne     bool
+	configDir    string
+	tlsExtra     string
+	adminExtra   string
+	apiExtra     string
+	flags        []cli.Flag
+	app          *cli.App
+	allInOneArgs combinedArgs
+)
+
+// Arguments of each service when they run in one process
+type combinedArgs struct {
+	TLS   []string
+	Admin []string
+	API   []string
+}
+
+// Initialization code
+func init() {
+	// Initialize CLI flags
+	flags = []cli.Flag{
+		&cli.BoolFlag{
+			Name:        "all-in-one",
+			Aliases:     []string{"a"},
+			Value:       false,
+			Usage:       "Run osctrl-tls, osctrl-admin and osctrl-api in one process",
+			EnvVars:     []string{"OSCTRL_ALL_IN_ONE"},
+			Destination: &allInOne,
+		},
+		&cli.StringFlag{
+			Name:        "config-dir",
+			Aliases:     []string{"C"},
+			Value:       defConfigurationDir,
+			Usage:       "Load tls.json, admin.json, api.json, db.json and redis.json from `DIR`",
+			EnvVars:     []string{"OSCTRL_CONFIG_DIR"},
+			Destination: &configDir,
+		},
+		&cli.StringFlag{
+			Name:        "tls-args",
+			Value:       "",
+			Usage:       "Additional arguments for osctrl-tls, as in its own binary",
+			EnvVars:     []string{"OSCTRL_TLS_ARGS"},
+			Destination: &tlsExtra,
+		},
+		&cli.StringFlag{
+			Name:        "admin-args",
+			Value:       "",
+			Usage:       "Additional arguments for osctrl-admin, as in its own binary",
+			EnvVars:     []string{"OSCTRL_ADMIN_ARGS"},
+			Destination: &adminExtra,
+		},
+		&cli.StringFlag{
+			Name:        "api-args",
+			Value:       "",
+			Usage:       "Additional arguments for osctrl-api, as in its own binary",
+			EnvVars:     []string{"OSCTRL_API_ARGS"},
+			Destination: &apiExtra,
+		},
+	}
+}
+
+// Helper to generate the arguments of one service, with the configuration files from the folder
+func serviceArgs(dir, service, extra string) []string {
+	args := []string{
+		projectName + "-" + service,
+		"--config", "--config-file", filepath.Join(dir, service+".json"),
+		"--db", "--db-file", filepath.Join(dir, "db.json"),
+		"--redis", "--redis-file", filepath.Join(dir, "redis.json"),
+	}
+	return append(args, strings.Fields(extra)...)
+}
+
+// Helper to generate the arguments of all services
+func prepareArgs(dir, tlsExtra, adminExtra, apiExtra string) combinedArgs {
+	return combinedArgs{
+		TLS:   serviceArgs(dir, settings.ServiceTLS, tlsExtra),
+		Admin: serviceArgs(dir, settings.ServiceAdmin, adminExtra),
+		API:   serviceArgs(dir, settings.ServiceAPI, apiExtra),
+	}
+}
+
+// Services to run in one process, with the managers they share
+type combinedServices struct {
+	// Configure all services with their arguments
+	Configure func(args combinedArgs) error
+	// Create the managers shared by all services
+	CreateShared func() (*service.Shared, error)
+	// Start the background jobs of the shared managers, once for all services
+	Monitor func(ctx context.Context, shared *service.Shared)
+	// Run each service until the context is done
+	Runs []func(ctx context.Context, shared *service.Shared)
+}
+
+// Services of osctrl-tls, osctrl-admin and osctrl-api
+var allServices = combinedServices{
+	Configure: func(args combinedArgs) error {
+		if err := tlsserver.Configure(args.TLS); err != nil {
+			return fmt.Errorf("Error configuring %s - %v", settings.ServiceTLS, err)
+		}
+		if err := adminserver.Configure(args.Admin); err != nil {
+			return fmt.Errorf("Error configuring %s - %v", settings.ServiceAdmin, err)
+		}
+		if err := apiserver.Configure(args.API); err != nil {
+			return fmt.Errorf("Error configuring %s - %v", settings.ServiceAPI, err)
+		}
+		return nil
+	},
+	CreateShared: tlsserver.CreateShared,
+	Monitor: func(ctx context.Context, shared *service.Shared) {
+		hostname, _ := os.Hostname()
+		shared.StartPoolMonitor(ctx, hostname, projectName, tlsserver.SendPoolMetrics)
+	},
+	Runs: []func(context.Context, *service.Shared){tlsserver.Run, adminserver.Run, apiserver.Run},
+}
+
+// Function to run all services in one process until the context is done. Managers are created once,
+// with the configuration of osctrl-tls, and each service listens in the port of its configuration.
+func runAllInOne(ctx context.Context, args combinedArgs) error {
+	return runServices(ctx, args, allServices)
+}
+
+// Helper to run services in one process, sharing managers and the context that stops them
+func runServices(ctx context.Context, args combinedArgs, services combinedServices) error {
+	if err := services.Configure(args); err != nil {
+		return err
+	}
+	shared, err := services.CreateShared()
+	if err != nil {
+		return err
+	}
+	// One pool, checked once for all services
+	services.Monitor(ctx, shared)
+	// Each service stops gracefully when the context is done
+	var wg sync.WaitGroup
+	for _, run := range services.Runs {
+		wg.Add(1)
+		go func(run func(context.Context, *service.Shared)) {
+			defer wg.Done()
+			run(ctx, shared)
+		}(run)
+	}
+	wg.Wait()
+	// Pending writes of the shared managers, once no service uses them
+	return shared.Stop(shutdownTimeout)
+}
+
+// Action to run when no flags are provided to run checks and prepare data
+func cliAction(c *cli.Context) error {
+	if !allInOne {
+		return fmt.Errorf("osctrl runs all services with --all-in-one, or use osctrl-tls, osctrl-admin and osctrl-api")
+	}
+	allInOneArgs = prepareArgs(configDir, tlsExtra, adminExtra, apiExtra)
+	return nil
+}
+
+func main() {
+	// Initiate CLI and parse arguments
+	app = cli.NewApp()
+	app.Name = projectName
+	app.Usage = appDescription
+	app.Version = serviceVersion
+	app.Description = appDescription
+	app.Flags = flags
+	// Define this command for help to exit when help flag is passed
+	app.Commands = []*cli.Command{
+		{
+			Name: "help",
+			Action: func(c *cli.Context) error {
+				cli.ShowAppHelpAndExit(c, 0)
+				return nil
+			},
+		},
+	}
+	app.Action = cliAction
+	if err := app.Run(os.Args); err != nil {
+		log.Fatal(err)
+	}
+	// Help and version do not run the action
+	if !allInOne {
+		return
+	}
+	// One signal stops all services
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	log.Printf("%s - Starting all services", version.Info(projectName))
+	if err := runAllInOne(ctx, allInOneArgs); err != nil {
+		log.Fatal(err)
+	}
+}
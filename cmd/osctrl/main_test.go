@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/stretchr/testify/assert"
+	"osctrl/service"
+)
+
+func TestPrepareArgs(t *testing.T) {
+	args := prepareArgs("/etc/osctrl", "--port 9000", "", "--port 9002 --jwt")
+	assert.Equal(t, []string{
+		"osctrl-tls",
+		"--config", "--config-file", "/etc/osctrl/tls.json",
+		"--db", "--db-file", "/etc/osctrl/db.json",
+		"--redis", "--redis-file", "/etc/osctrl/redis.json",
+		"--port", "9000",
+	}, args.TLS)
+	assert.Equal(t, "/etc/osctrl/admin.json", args.Admin[3])
+	assert.Len(t, args.Admin, 10)
+	assert.Equal(t, []string{"--port", "9002", "--jwt"}, args.API[10:])
+}
+
+func TestRunServices(t *testing.T) {
+	shared := &service.Shared{Envs: &environments.Environment{}}
+	args := prepareArgs(defConfigurationDir, "", "", "")
+	ctx, cancel := context.WithCancel(context.Background())
+	var monitors int
+	started := make(chan *service.Shared, 3)
+	run := func(ctx context.Context, s *service.Shared) {
+		started <- s
+		<-ctx.Done()
+	}
+	services := combinedServices{
+		Configure: func(a combinedArgs) error {
+			assert.Equal(t, args, a)
+			return nil
+		},
+		CreateShared: func() (*service.Shared, error) { return shared, nil },
+		Monitor: func(monitorCtx context.Context, s *service.Shared) {
+			monitors++
+			assert.Equal(t, ctx, monitorCtx)
+			assert.Equal(t, shared, s)
+		},
+		Runs: []func(context.Context, *service.Shared){run, run, run},
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- runServices(ctx, args, services)
+	}()
+	// Every service runs with the same managers
+	for i := 0; i < 3; i++ {
+		select {
+		case s := <-started:
+			assert.Equal(t, shared, s)
+		case <-time.After(time.Minute):
+			t.Fatal("services did not start")
+		}
+	}
+	// One cancellation stops all services
+	cancel()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Minute):
+		t.Fatal("services did not stop")
+	}
+	assert.Equal(t, 1, monitors)
+}
+
+func TestRunServicesConfigureError(t *testing.T) {
+	services := combinedServices{
+		Configure: func(combinedArgs) error { return assert.AnError },
+		CreateShared: func() (*service.Shared, error) {
+			t.Fatal("managers created without configuration")
+			return nil, nil
+		},
+	}
+	assert.Equal(t, assert.AnError, runServices(context.Background(), combinedArgs{}, services))
+}
+
+// Boots all services against the database and redis of OSCTRL_INTEGRATION_DIR, a folder prepared as
+// deployments are, with config, data, tmpl_admin and static, and requests one endpoint of each service
+func TestAllInOne(t *testing.T) {
+	dir := os.Getenv("OSCTRL_INTEGRATION_DIR")
+	if dir == "" {
+		t.Skip("OSCTRL_INTEGRATION_DIR is not set")
+	}
+	wd, err := os.Getwd()
+	assert.NoError(t, err)
+	assert.NoError(t, os.Chdir(dir))
+	defer func() { _ = os.Chdir(wd) }()
+	args := prepareArgs(defConfigurationDir, "--port 19080", "--port 19081", "--port 19082 --jwt")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- runAllInOne(ctx, args)
+	}()
+	for _, url := range []string{
+		"http://127.0.0.1:19080/health",
+		"http://127.0.0.1:19081/health",
+		"http://127.0.0.1:19082/health",
+	} {
+		assert.Eventually(t, func() bool {
+			resp, err := http.Get(url)
+			if err != nil {
+				return false
+			}
+			resp.Body.Close()
+			return resp.StatusCode == http.StatusOK
+		}, time.Minute, 500*time.Millisecond, url)
+	}
+	// One cancellation stops all services
+	cancel()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Minute):
+		t.Fatal("services did not stop")
+	}
+}
@@ -0,0 +1,406 @@
+package environments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmpsec/osctrl/backend"
+	"gorm.io/gorm"
+)
+
+// Types of entities whose downloads are recorded in the data access log
+const (
+	AccessCarve       string = "carve"
+	AccessCarveFile   string = "carve_file"
+	AccessQueryResult string = "query_result"
+	AccessNodeExport  string = "node_export"
+	AccessReport      string = "access_report"
+)
+
+// AccessEntities to list all the types of entities in the data access log, in the order they are shown in filters
+var AccessEntities = []string{
+	AccessCarve,
+	AccessCarveFile,
+	AccessQueryResult,
+	AccessNodeExport,
+	AccessReport,
+}
+
+// Credentials used to download, a session of osctrl-admin, a token of a user or a service key of osctrl-api
+const (
+	AccessViaSession string = "session"
+	AccessViaToken   string = "token"
+	AccessViaKey     string = "key"
+)
+
+const (
+	// DefaultAccessRetention is the default days to keep the data access log
+	DefaultAccessRetention int = 365
+	// DefaultAccessInterval is the default interval to prune the data access log
+	DefaultAccessInterval = 24 * time.Hour
+	// DefaultAccessQueueSize is the default maximum number of accesses waiting to be written
+	DefaultAccessQueueSize int = 10000
+	// DefaultAccessLimit is the default number of data accesses per page
+	DefaultAccessLimit int = 100
+	// MaxAccessLimit is the maximum number of data accesses per page
+	MaxAccessLimit int = 5000
+	// Accesses written together by the recorder
+	accessBatch = 100
+)
+
+// ErrAccessQueueFull is returned when an access is dropped because the queue is full
+var ErrAccessQueueFull = errors.New("data access queue is full")
+
+// ErrAccessQueueClosed is returned when an access is dropped because the queue is shutting down
+var ErrAccessQueueClosed = errors.New("data access queue is closed")
+
+// DataAccess to record one download of carved files, query results or node data. Entries are never updated
+// and only read for reports, so they are kept apart from the activity of environments.
+type DataAccess struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt     time.Time `gorm:"index" json:"created_at"`
+	EnvironmentID uint      `gorm:"index" json:"environment_id"`
+	Actor         string    `gorm:"index" json:"actor"`
+	Via           string    `json:"via"`
+	EntityType    string    `gorm:"index:idx_data_access_entity" json:"entity_type"`
+	Entity        string    `gorm:"index:idx_data_access_entity" json:"entity"`
+	Detail        string    `json:"detail"`
+	Bytes         int64     `json:"bytes"`
+	IPAddress     string    `json:"ip_address"`
+}
+
+// AccessFilter to select data accesses, zero values match everything
+type AccessFilter struct {
+	EnvironmentIDs []uint
+	Actor          string
+	EntityTypes    []string
+	Entity         string
+	From           time.Time
+	To             time.Time
+	Page           int
+	Limit          int
+}
+
+// Normalize to apply the defaults and maximum to the page and limit of a filter
+func (f AccessFilter) Normalize() AccessFilter {
+	if f.Page < 1 {
+		f.Page = 1
+	}
+	if f.Limit <= 0 {
+		f.Limit = DefaultAccessLimit
+	}
+	if f.Limit > MaxAccessLimit {
+		f.Limit = MaxAccessLimit
+	}
+	return f
+}
+
+// ParseAccessFilter to get the filter of data accesses from query parameters: user, entity_type, entity, from,
+// to, page and limit. Types of entities can be repeated or separated by commas. Environments are resolved by callers.
+func ParseAccessFilter(q url.Values, now time.Time) (AccessFilter, error) {
+	var filter AccessFilter
+	var err error
+	if filter.From, err = ParseSince(q.Get("from"), now); err != nil {
+		return filter, err
+	}
+	if filter.To, err = ParseSince(q.Get("to"), now); err != nil {
+		return filter, err
+	}
+	for _, v := range q["entity_type"] {
+		for _, t := range strings.Split(v, ",") {
+			t = strings.TrimSpace(t)
+			if t == "" {
+				continue
+			}
+			if !validAccessEntity(t) {
+				return filter, fmt.Errorf("invalid entity type %s", t)
+			}
+			filter.EntityTypes = append(filter.EntityTypes, t)
+		}
+	}
+	filter.Actor = strings.TrimSpace(q.Get("user"))
+	filter.Entity = strings.TrimSpace(q.Get("entity"))
+	if v := q.Get("page"); v != "" {
+		if filter.Page, err = strconv.Atoi(v); err != nil {
+			return filter, fmt.Errorf("invalid page %s", v)
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil {
+			return filter, fmt.Errorf("invalid limit %s", v)
+		}
+	}
+	return filter.Normalize(), nil
+}
+
+// Helper to check if a type of entity of the data access log is valid
+func validAccessEntity(entityType string) bool {
+	for _, t := range AccessEntities {
+		if t == entityType {
+			return true
+		}
+	}
+	return false
+}
+
+// LogicalDownload to check if a request starts a download, so ranged requests resuming it are not recorded again
+func LogicalDownload(rangeHeader string) bool {
+	rangeHeader = strings.TrimSpace(rangeHeader)
+	return rangeHeader == "" || strings.HasPrefix(rangeHeader, "bytes=0-")
+}
+
+// AccessCounter to count the bytes written to a response, to record them once the download ends
+type AccessCounter struct {
+	http.ResponseWriter
+	Bytes int64
+}
+
+// Write to send bytes to the response, counting them
+func (c *AccessCounter) Write(b []byte) (int, error) {
+	n, err := c.ResponseWriter.Write(b)
+	c.Bytes += int64(n)
+	return n, err
+}
+
+// Flush to send buffered bytes for streamed responses
+func (c *AccessCounter) Flush() {
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// AccessRecorder is a bounded queue of data accesses written in batches by one worker, so downloads are never
+// slowed by the database. Accesses are dropped and logged when the queue is full.
+type AccessRecorder struct {
+	entries chan DataAccess
+	store   func([]DataAccess) error
+	mux     sync.Mutex
+	dropped int
+	closed  bool
+	done    chan struct{}
+}
+
+// NewAccessRecorder to initialize the queue of data accesses, the worker is started with Start
+func NewAccessRecorder(size int, store func([]DataAccess) error) *AccessRecorder {
+	if size <= 0 {
+		size = DefaultAccessQueueSize
+	}
+	return &AccessRecorder{
+		entries: make(chan DataAccess, size),
+		store:   store,
+		done:    make(chan struct{}),
+	}
+}
+
+// Start to launch the worker that writes the queued accesses
+func (r *AccessRecorder) Start() {
+	go func() {
+		defer close(r.done)
+		for a := range r.entries {
+			batch := []DataAccess{a}
+		drain:
+			for len(batch) < accessBatch {
+				select {
+				case next, ok := <-r.entries:
+					if !ok {
+						break drain
+					}
+					batch = append(batch, next)
+				default:
+					break drain
+				}
+			}
+			if err := r.store(batch); err != nil {
+				log.Printf("error recording %d data accesses %v", len(batch), err)
+			}
+		}
+	}()
+}
+
+// Record to queue one access without waiting for it to be written
+func (r *AccessRecorder) Record(a DataAccess) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.closed {
+		r.dropped++
+		return ErrAccessQueueClosed
+	}
+	select {
+	case r.entries <- a:
+		return nil
+	default:
+		r.dropped++
+		return ErrAccessQueueFull
+	}
+}
+
+// Dropped to get how many accesses were dropped and reset the counter
+func (r *AccessRecorder) Dropped() int {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	dropped := r.dropped
+	r.dropped = 0
+	return dropped
+}
+
+// Stop to stop accepting accesses and wait for the queued ones to be written
+func (r *AccessRecorder) Stop(timeout time.Duration) error {
+	r.mux.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.entries)
+	}
+	r.mux.Unlock()
+	select {
+	case <-r.done:
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("timeout writing data accesses, %d pending", len(r.entries))
+	}
+}
+
+// StartAccessLog to write data accesses asynchronously with a bounded queue, only the first call starts it
+func (environment *Environment) StartAccessLog(size int) {
+	environment.accessOnce.Do(func() {
+		environment.access = NewAccessRecorder(size, environment.CreateAccesses)
+		environment.access.Start()
+	})
+}
+
+// StopAccessLog to write the pending data accesses before shutting down
+func (environment *Environment) StopAccessLog(timeout time.Duration) error {
+	if environment.access == nil {
+		return nil
+	}
+	return environment.access.Stop(timeout)
+}
+
+// RecordAccess to record one download in the data access log, without waiting for it to be written
+func (environment *Environment) RecordAccess(a DataAccess) {
+	if a.CreatedAt.IsZero() {
+		a.CreatedAt = time.Now()
+	}
+	if environment.access == nil {
+		go func() {
+			if err := environment.CreateAccesses([]DataAccess{a}); err != nil {
+				log.Printf("error recording data access %v", err)
+			}
+		}()
+		return
+	}
+	if err := environment.access.Record(a); err != nil {
+		log.Printf("data access of %s %s by %s not recorded - %v", a.EntityType, a.Entity, a.Actor, err)
+	}
+}
+
+// CreateAccesses to write data accesses to the log
+func (environment *Environment) CreateAccesses(entries []DataAccess) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	if err := environment.DB.CreateInBatches(entries, accessBatch).Error; err != nil {
+		return fmt.Errorf("CreateInBatches %v", err)
+	}
+	return nil
+}
+
+// AccessLog to get one page of the data access log, newest first, with the total of matching entries
+func (environment *Environment) AccessLog(filter AccessFilter) ([]DataAccess, int64, error) {
+	var entries []DataAccess
+	var total int64
+	filter = filter.Normalize()
+	scope := func(db *gorm.DB) *gorm.DB {
+		if filter.EnvironmentIDs != nil {
+			db = db.Where("environment_id IN ?", filter.EnvironmentIDs)
+		}
+		if filter.Actor != "" {
+			db = db.Where("actor = ?", filter.Actor)
+		}
+		if len(filter.EntityTypes) > 0 {
+			db = db.Where("entity_type IN ?", filter.EntityTypes)
+		}
+		if filter.Entity != "" {
+			db = db.Where("entity = ?", filter.Entity)
+		}
+		if !filter.From.IsZero() {
+			db = db.Where("created_at >= ?", filter.From)
+		}
+		if !filter.To.IsZero() {
+			db = db.Where("created_at <= ?", filter.To)
+		}
+		return db
+	}
+	if err := environment.read().Model(&DataAccess{}).Scopes(scope).Count(&total).Error; err != nil {
+		return entries, total, fmt.Errorf("Count %v", err)
+	}
+	err := environment.read().Scopes(scope).Order("created_at desc, id desc").Limit(filter.Limit).Offset((filter.Page - 1) * filter.Limit).Find(&entries).Error
+	if err != nil {
+		return entries, total, fmt.Errorf("Find %v", err)
+	}
+	return entries, total, nil
+}
+
+// EntityAccesses to get the latest accesses to one entity of an environment, for the panels of its details
+func (environment *Environment) EntityAccesses(envid uint, entityTypes []string, entity string) ([]DataAccess, error) {
+	entries, _, err := environment.AccessLog(AccessFilter{
+		EnvironmentIDs: []uint{envid},
+		EntityTypes:    entityTypes,
+		Entity:         entity,
+	})
+	return entries, err
+}
+
+// PruneAccesses to remove the data accesses older than the retention in days, 0 keeps them forever
+func (environment *Environment) PruneAccesses(now time.Time, retention int) error {
+	if retention <= 0 {
+		return nil
+	}
+	cutoff := now.AddDate(0, 0, -retention)
+	if err := environment.DB.Where("created_at < ?", cutoff).Delete(&DataAccess{}).Error; err != nil {
+		return fmt.Errorf("Delete %v", err)
+	}
+	return nil
+}
+
+// StartAccessPrune to prune the data access log periodically, only by the leader if there is a lock
+func (environment *Environment) StartAccessPrune(ctx context.Context, leader *backend.LeaderLock, interval time.Duration, retention func() int) {
+	if interval <= 0 {
+		interval = DefaultAccessInterval
+	}
+	run := func() {
+		if leader != nil {
+			isLeader, err := leader.Acquire()
+			if err != nil {
+				log.Printf("error acquiring leader lock %v", err)
+				return
+			}
+			if !isLeader {
+				return
+			}
+		}
+		if err := environment.PruneAccesses(time.Now(), retention()); err != nil {
+			log.Printf("error pruning data access log %v", err)
+		}
+	}
+	go func() {
+		run()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				run()
+			}
+		}
+	}()
+}
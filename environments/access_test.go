@@ -0,0 +1,115 @@
+package environments
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAccessFilter(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	q := url.Values{}
+	q.Set("user", " alice ")
+	q.Add("entity_type", "carve,carve_file")
+	q.Add("entity_type", AccessQueryResult)
+	q.Set("from", "7d")
+	q.Set("page", "3")
+	filter, err := ParseAccessFilter(q, now)
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", filter.Actor)
+	assert.Equal(t, []string{AccessCarve, AccessCarveFile, AccessQueryResult}, filter.EntityTypes)
+	assert.Equal(t, now.Add(-7*24*time.Hour), filter.From)
+	assert.True(t, filter.To.IsZero())
+	assert.Equal(t, 3, filter.Page)
+	assert.Equal(t, DefaultAccessLimit, filter.Limit)
+}
+
+func TestParseAccessFilterLimits(t *testing.T) {
+	filter, err := ParseAccessFilter(url.Values{"limit": {"100000"}, "page": {"-2"}}, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, MaxAccessLimit, filter.Limit)
+	assert.Equal(t, 1, filter.Page)
+}
+
+func TestParseAccessFilterInvalid(t *testing.T) {
+	_, err := ParseAccessFilter(url.Values{"entity_type": {"carve,secrets"}}, time.Now())
+	assert.Error(t, err)
+	_, err = ParseAccessFilter(url.Values{"page": {"first"}}, time.Now())
+	assert.Error(t, err)
+}
+
+func TestLogicalDownload(t *testing.T) {
+	assert.True(t, LogicalDownload(""))
+	assert.True(t, LogicalDownload("bytes=0-"))
+	assert.True(t, LogicalDownload("bytes=0-1023"))
+	assert.False(t, LogicalDownload("bytes=1024-"))
+}
+
+func TestAccessCounter(t *testing.T) {
+	rec := httptest.NewRecorder()
+	counter := &AccessCounter{ResponseWriter: rec}
+	_, _ = counter.Write([]byte("hello "))
+	_, _ = counter.Write([]byte("world"))
+	counter.Flush()
+	assert.Equal(t, int64(11), counter.Bytes)
+	assert.Equal(t, "hello world", rec.Body.String())
+	assert.True(t, rec.Flushed)
+}
+
+func TestAccessRecorder(t *testing.T) {
+	var mux sync.Mutex
+	var stored []DataAccess
+	batches := 0
+	recorder := NewAccessRecorder(10, func(entries []DataAccess) error {
+		mux.Lock()
+		defer mux.Unlock()
+		stored = append(stored, entries...)
+		batches++
+		return nil
+	})
+	recorder.Start()
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, recorder.Record(DataAccess{Entity: "q"}))
+	}
+	assert.NoError(t, recorder.Stop(time.Second))
+	assert.Len(t, stored, 5)
+	assert.GreaterOrEqual(t, batches, 1)
+	assert.ErrorIs(t, recorder.Record(DataAccess{}), ErrAccessQueueClosed)
+	assert.Equal(t, 1, recorder.Dropped())
+	assert.Equal(t, 0, recorder.Dropped())
+}
+
+func TestAccessRecorderFull(t *testing.T) {
+	// Without the worker started, the queue fills up and accesses are dropped
+	recorder := NewAccessRecorder(2, func(entries []DataAccess) error { return nil })
+	assert.NoError(t, recorder.Record(DataAccess{}))
+	assert.NoError(t, recorder.Record(DataAccess{}))
+	assert.ErrorIs(t, recorder.Record(DataAccess{}), ErrAccessQueueFull)
+	assert.Equal(t, 1, recorder.Dropped())
+	recorder.Start()
+	assert.NoError(t, recorder.Stop(time.Second))
+}
+
+func TestStartAccessLogOnce(t *testing.T) {
+	// Services sharing the environments in one process start the access log once
+	environment := &Environment{}
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			environment.StartAccessLog(DefaultAccessQueueSize)
+		}()
+	}
+	wg.Wait()
+	first := environment.access
+	assert.NotNil(t, first)
+	environment.StartAccessLog(DefaultAccessQueueSize)
+	assert.Same(t, first, environment.access)
+	assert.NoError(t, environment.StopAccessLog(time.Second))
+	assert.NoError(t, environment.StopAccessLog(time.Second))
+}
@@ -0,0 +1,439 @@
+package environments
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Kinds of changes recorded in the activity of environments
+const (
+	ActivityConfiguration string = "configuration"
+	ActivityOptions       string = "options"
+	ActivitySchedule      string = "schedule"
+	ActivityPacks         string = "packs"
+	ActivityDecorators    string = "decorators"
+	ActivityATC           string = "atc"
+	ActivityFlags         string = "flags"
+	ActivityIntervals     string = "intervals"
+	ActivitySecret        string = "secret"
+	ActivityEnroll        string = "enroll"
+	ActivityRemove        string = "remove"
+	ActivityCertificate   string = "certificate"
+	ActivityEnrollFields  string = "enroll_fields"
+	ActivityProfiles      string = "profiles"
+	ActivityWindowsEvents string = "windows_events"
+	ActivityPermissions   string = "permissions"
+	ActivityDebug         string = "debug"
+	ActivityCarves        string = "carves"
+	ActivityIdentity      string = "identity"
+	ActivityStorm         string = "storm"
+	ActivityAPIKeys       string = "apikeys"
+	ActivityStatusTokens  string = "status_tokens"
+	ActivityResponses     string = "responses"
+	ActivityLocale        string = "locale"
+	ActivityPlatforms     string = "platforms"
+	ActivityOsquery       string = "osquery"
+	ActivityTemplate      string = "template"
+	ActivityQueries       string = "queries"
+	ActivityShares        string = "shares"
+	ActivityPolicies      string = "policies"
+	ActivityStorage       string = "storage"
+	ActivityPseudonyms    string = "pseudonyms"
+	ActivityQuickActions  string = "quick_actions"
+	ActivityEnrollLoops   string = "enroll_loops"
+	ActivityScheduled     string = "scheduled_changes"
+	ActivityMutes         string = "mutes"
+	ActivityDenylist      string = "denylist"
+	ActivityMerges        string = "node_merges"
+	ActivityArchives      string = "query_archives"
+	ActivityPauses        string = "node_pauses"
+	ActivityWebhooks      string = "webhooks"
+	ActivityNaming        string = "naming"
+	ActivityPackSync      string = "pack_sync"
+	ActivityPaths         string = "paths"
+	ActivityCategory      string = "category"
+)
+
+// ActivityKinds to list all the kinds of activity, in the order they are shown in filters
+var ActivityKinds = []string{
+	ActivityConfiguration,
+	ActivityOptions,
+	ActivitySchedule,
+	ActivityPacks,
+	ActivityDecorators,
+	ActivityATC,
+	ActivityFlags,
+	ActivityIntervals,
+	ActivitySecret,
+	ActivityEnroll,
+	ActivityRemove,
+	ActivityCertificate,
+	ActivityEnrollFields,
+	ActivityProfiles,
+	ActivityWindowsEvents,
+	ActivityPermissions,
+	ActivityDebug,
+	ActivityCarves,
+	ActivityIdentity,
+	ActivityStorm,
+	ActivityAPIKeys,
+	ActivityStatusTokens,
+	ActivityResponses,
+	ActivityLocale,
+	ActivityPlatforms,
+	ActivityOsquery,
+	ActivityTemplate,
+	ActivityQueries,
+	ActivityShares,
+	ActivityPolicies,
+	ActivityStorage,
+	ActivityPseudonyms,
+	ActivityQuickActions,
+	ActivityEnrollLoops,
+	ActivityScheduled,
+	ActivityMutes,
+	ActivityDenylist,
+	ActivityMerges,
+	ActivityArchives,
+	ActivityPauses,
+	ActivityWebhooks,
+	ActivityNaming,
+	ActivityPackSync,
+	ActivityPaths,
+	ActivityCategory,
+}
+
+const (
+	// DefaultActivityLimit is the default number of activity entries per page
+	DefaultActivityLimit int = 50
+	// MaxActivityLimit is the maximum number of activity entries per page
+	MaxActivityLimit int = 500
+)
+
+// EnvActivity to record a change in an environment, with the values before and after for diffs
+type EnvActivity struct {
+	gorm.Model
+	EnvironmentID uint   `gorm:"index"`
+	Kind          string `gorm:"index"`
+	Actor         string
+	Summary       string
+	Before        string
+	After         string
+	Changed       bool
+}
+
+// ActivityFilter to select the activity of an environment, zero values match everything
+type ActivityFilter struct {
+	Kinds []string
+	Actor string
+	From  time.Time
+	To    time.Time
+	Page  int
+	Limit int
+}
+
+// DiffLine to hold one line of the differences between two values, Op is one of " ", "+" or "-"
+type DiffLine struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// Normalize to apply the defaults and maximum to the page and limit of a filter
+func (f ActivityFilter) Normalize() ActivityFilter {
+	if f.Page < 1 {
+		f.Page = 1
+	}
+	if f.Limit <= 0 {
+		f.Limit = DefaultActivityLimit
+	}
+	if f.Limit > MaxActivityLimit {
+		f.Limit = MaxActivityLimit
+	}
+	return f
+}
+
+// ParseSince to parse the start of the activity, as a duration before now like 7d or 12h, a date or a RFC3339 time
+func ParseSince(value string, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if strings.HasSuffix(value, "d") {
+		if days, err := strconv.Atoi(strings.TrimSuffix(value, "d")); err == nil && days >= 0 {
+			return now.AddDate(0, 0, -days), nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %s, use a duration like 7d or 12h, a date or RFC3339", value)
+}
+
+// ParseActivityFilter to get the filter of activity from query parameters: from, to, kind, actor, page and limit.
+// Kinds can be repeated or separated by commas.
+func ParseActivityFilter(q url.Values, now time.Time) (ActivityFilter, error) {
+	var filter ActivityFilter
+	var err error
+	if filter.From, err = ParseSince(q.Get("from"), now); err != nil {
+		return filter, err
+	}
+	if filter.To, err = ParseSince(q.Get("to"), now); err != nil {
+		return filter, err
+	}
+	for _, k := range q["kind"] {
+		for _, kind := range strings.Split(k, ",") {
+			kind = strings.TrimSpace(kind)
+			if kind == "" {
+				continue
+			}
+			if !validActivityKind(kind) {
+				return filter, fmt.Errorf("invalid kind %s", kind)
+			}
+			filter.Kinds = append(filter.Kinds, kind)
+		}
+	}
+	filter.Actor = strings.TrimSpace(q.Get("actor"))
+	if v := q.Get("page"); v != "" {
+		if filter.Page, err = strconv.Atoi(v); err != nil {
+			return filter, fmt.Errorf("invalid page %s", v)
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil {
+			return filter, fmt.Errorf("invalid limit %s", v)
+		}
+	}
+	return filter.Normalize(), nil
+}
+
+// Helper to check if a kind of activity is valid
+func validActivityKind(kind string) bool {
+	for _, k := range ActivityKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// ActivityValue to get the value of an environment that changes with each kind of activity.
+// Secrets are never recorded, enroll and remove links only record when they expire.
+func ActivityValue(env TLSEnvironment, kind string) string {
+	switch kind {
+	case ActivityConfiguration:
+		return env.Configuration
+	case ActivityOptions:
+		return env.Options
+	case ActivitySchedule:
+		return env.Schedule
+	case ActivityPacks:
+		return env.Packs
+	case ActivityDecorators:
+		return env.Decorators
+	case ActivityATC:
+		return env.ATC
+	case ActivityFlags:
+		return env.Flags
+	case ActivityCertificate:
+		return env.Certificate
+	case ActivityIntervals:
+		return fmt.Sprintf("config: %d\nlog: %d\nquery: %d", env.ConfigInterval, env.LogInterval, env.QueryInterval)
+	case ActivityEnroll:
+		return "expires: " + env.EnrollExpire.UTC().Format(time.RFC3339)
+	case ActivityRemove:
+		return "expires: " + env.RemoveExpire.UTC().Format(time.RFC3339)
+	case ActivityDebug:
+		return "debug_http: " + strconv.FormatBool(env.DebugHTTP)
+	case ActivityCarves:
+		return "carve_scan_policy: " + env.CarveScanPolicy
+	case ActivityIdentity:
+		return "identity_policy: " + env.IdentityPolicy
+	case ActivityStorm:
+		return "storm_threshold: " + strconv.Itoa(env.StormThreshold)
+	case ActivityLocale:
+		return "locale: " + env.Locale
+	case ActivityPlatforms:
+		return "allowed_platforms: " + env.AllowedPlatforms
+	case ActivityOsquery:
+		return "osquery_pin: " + env.OsqueryPin + "\nosquery_pins: " + env.OsqueryPins
+	case ActivityTemplate:
+		return fmt.Sprintf("template_id: %d\ntemplate_version: %d", env.TemplateID, env.TemplateVersion)
+	case ActivityPseudonyms:
+		return "pseudonym_columns: " + env.PseudonymColumns + "\npseudonym_tables: " + env.PseudonymTables
+	case ActivityEnrollLoops:
+		return fmt.Sprintf("enroll_loop_limit: %d\nenroll_loop_quiet: %d\nenroll_backoff: %t", env.EnrollLoopLimit, env.EnrollLoopQuiet, env.EnrollBackoff)
+	case ActivityNaming:
+		return fmt.Sprintf("naming_lowercase: %t\nnaming_domains: %s\nnaming_prefer_fqdn: %t", env.NamingLowercase, env.NamingDomains, env.NamingPreferFQDN)
+	case ActivityPaths:
+		return env.Paths().String()
+	case ActivityCategory:
+		return "category: " + env.Category
+	}
+	return ""
+}
+
+// RecordChange to record a change of an environment, comparing the values before with the current ones
+func (environment *Environment) RecordChange(before TLSEnvironment, kind, actor, summary string) error {
+	return environment.recordChange(before, kind, actor, summary, "")
+}
+
+// RecordConfigChange to record a change of the configuration of an environment, keeping its impact with the version
+func (environment *Environment) RecordConfigChange(before TLSEnvironment, kind, actor, summary string, impact ConfigImpact) error {
+	raw, err := json.Marshal(impact)
+	if err != nil {
+		return fmt.Errorf("Marshal %v", err)
+	}
+	return environment.recordChange(before, kind, actor, summary, string(raw))
+}
+
+// Helper to record a change of an environment, with the impact of configuration changes when known
+func (environment *Environment) recordChange(before TLSEnvironment, kind, actor, summary, impact string) error {
+	var after TLSEnvironment
+	// Read from the primary, the change was just written
+	if err := environment.DB.Where("id = ?", before.ID).First(&after).Error; err != nil {
+		return fmt.Errorf("First %v", err)
+	}
+	if err := environment.RecordActivity(before.ID, kind, actor, summary, ActivityValue(before, kind), ActivityValue(after, kind)); err != nil {
+		return err
+	}
+	// Changes to the configuration are also kept as versions
+	if IsConfigKind(kind) {
+		if _, err := environment.RecordConfigVersion(before, after, actor, summary, impact); err != nil {
+			return fmt.Errorf("RecordConfigVersion %v", err)
+		}
+	}
+	return nil
+}
+
+// RecordActivity to record a change in an environment by an actor
+func (environment *Environment) RecordActivity(envid uint, kind, actor, summary, before, after string) error {
+	a := EnvActivity{
+		EnvironmentID: envid,
+		Kind:          kind,
+		Actor:         actor,
+		Summary:       summary,
+		Before:        before,
+		After:         after,
+		Changed:       before != after,
+	}
+	if err := environment.DB.Create(&a).Error; err != nil {
+		return fmt.Errorf("Create %v", err)
+	}
+	return nil
+}
+
+// Activity to get one page of the activity of an environment, newest first, with the total of matching entries
+func (environment *Environment) Activity(envid uint, filter ActivityFilter) ([]EnvActivity, int64, error) {
+	var entries []EnvActivity
+	var total int64
+	filter = filter.Normalize()
+	scope := func(db *gorm.DB) *gorm.DB {
+		db = db.Where("environment_id = ?", envid)
+		if len(filter.Kinds) > 0 {
+			db = db.Where("kind IN ?", filter.Kinds)
+		}
+		if filter.Actor != "" {
+			db = db.Where("actor = ?", filter.Actor)
+		}
+		if !filter.From.IsZero() {
+			db = db.Where("created_at >= ?", filter.From)
+		}
+		if !filter.To.IsZero() {
+			db = db.Where("created_at <= ?", filter.To)
+		}
+		return db
+	}
+	if err := environment.read().Model(&EnvActivity{}).Scopes(scope).Count(&total).Error; err != nil {
+		return entries, total, fmt.Errorf("Count %v", err)
+	}
+	// Values are only needed to show diffs of one entry
+	err := environment.read().Scopes(scope).Omit("before", "after").Order("created_at desc, id desc").Limit(filter.Limit).Offset((filter.Page - 1) * filter.Limit).Find(&entries).Error
+	if err != nil {
+		return entries, total, fmt.Errorf("Find %v", err)
+	}
+	return entries, total, nil
+}
+
+// GetActivity to get one entry of the activity of an environment, with the values before and after
+func (environment *Environment) GetActivity(envid, id uint) (EnvActivity, error) {
+	var a EnvActivity
+	if err := environment.read().Where("environment_id = ? AND id = ?", envid, id).First(&a).Error; err != nil {
+		return a, err
+	}
+	return a, nil
+}
+
+// DiffLines to get the line differences between two values, using the longest common subsequence
+func DiffLines(before, after string) []DiffLine {
+	a := splitLines(before)
+	b := splitLines(after)
+	// Common prefix and suffix are kept out of the table, most edits are small
+	start := 0
+	for start < len(a) && start < len(b) && a[start] == b[start] {
+		start++
+	}
+	endA, endB := len(a), len(b)
+	for endA > start && endB > start && a[endA-1] == b[endB-1] {
+		endA--
+		endB--
+	}
+	var diff []DiffLine
+	for _, l := range a[:start] {
+		diff = append(diff, DiffLine{Op: " ", Text: l})
+	}
+	ma, mb := a[start:endA], b[start:endB]
+	lcs := make([][]int, len(ma)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(mb)+1)
+	}
+	for i := len(ma) - 1; i >= 0; i-- {
+		for j := len(mb) - 1; j >= 0; j-- {
+			if ma[i] == mb[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(ma) || j < len(mb) {
+		switch {
+		case i < len(ma) && j < len(mb) && ma[i] == mb[j]:
+			diff = append(diff, DiffLine{Op: " ", Text: ma[i]})
+			i++
+			j++
+		case j < len(mb) && (i == len(ma) || lcs[i][j+1] > lcs[i+1][j]):
+			diff = append(diff, DiffLine{Op: "+", Text: mb[j]})
+			j++
+		default:
+			diff = append(diff, DiffLine{Op: "-", Text: ma[i]})
+			i++
+		}
+	}
+	for _, l := range a[endA:] {
+		diff = append(diff, DiffLine{Op: " ", Text: l})
+	}
+	return diff
+}
+
+// Helper to split a value in lines, an empty value has no lines
+func splitLines(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(strings.ReplaceAll(value, "\r\n", "\n"), "\n"), "\n")
+}
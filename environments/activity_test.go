@@ -0,0 +1,73 @@
+package environments
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2022, 6, 15, 12, 0, 0, 0, time.UTC)
+	since, err := ParseSince("7d", now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2022, 6, 8, 12, 0, 0, 0, time.UTC), since)
+	since, err = ParseSince("90m", now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2022, 6, 15, 10, 30, 0, 0, time.UTC), since)
+	since, err = ParseSince("2022-06-01", now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC), since)
+	since, err = ParseSince("", now)
+	assert.NoError(t, err)
+	assert.True(t, since.IsZero())
+	_, err = ParseSince("last week", now)
+	assert.Error(t, err)
+}
+
+func TestDiffLines(t *testing.T) {
+	before := "{\n  \"interval\": 60,\n  \"query\": \"SELECT 1\"\n}\n"
+	after := "{\n  \"interval\": 300,\n  \"query\": \"SELECT 1\",\n  \"removed\": false\n}\n"
+	assert.Equal(t, []DiffLine{
+		{Op: " ", Text: "{"},
+		{Op: "-", Text: "  \"interval\": 60,"},
+		{Op: "-", Text: "  \"query\": \"SELECT 1\""},
+		{Op: "+", Text: "  \"interval\": 300,"},
+		{Op: "+", Text: "  \"query\": \"SELECT 1\","},
+		{Op: "+", Text: "  \"removed\": false"},
+		{Op: " ", Text: "}"},
+	}, DiffLines(before, after))
+	assert.Equal(t, []DiffLine{{Op: "+", Text: "new"}}, DiffLines("", "new"))
+	assert.Nil(t, DiffLines("", ""))
+}
+
+func TestActivityFilterNormalize(t *testing.T) {
+	f := ActivityFilter{}.Normalize()
+	assert.Equal(t, 1, f.Page)
+	assert.Equal(t, DefaultActivityLimit, f.Limit)
+	f = ActivityFilter{Page: 3, Limit: 10000}.Normalize()
+	assert.Equal(t, 3, f.Page)
+	assert.Equal(t, MaxActivityLimit, f.Limit)
+}
+
+func TestParseActivityFilter(t *testing.T) {
+	now := time.Date(2022, 6, 15, 12, 0, 0, 0, time.UTC)
+	f, err := ParseActivityFilter(url.Values{
+		"from":  {"7d"},
+		"kind":  {"configuration,packs", "secret"},
+		"actor": {"admin"},
+		"page":  {"2"},
+	}, now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2022, 6, 8, 12, 0, 0, 0, time.UTC), f.From)
+	assert.True(t, f.To.IsZero())
+	assert.Equal(t, []string{ActivityConfiguration, ActivityPacks, ActivitySecret}, f.Kinds)
+	assert.Equal(t, "admin", f.Actor)
+	assert.Equal(t, 2, f.Page)
+	assert.Equal(t, DefaultActivityLimit, f.Limit)
+	_, err = ParseActivityFilter(url.Values{"kind": {"unknown"}}, now)
+	assert.Error(t, err)
+	_, err = ParseActivityFilter(url.Values{"limit": {"many"}}, now)
+	assert.Error(t, err)
+}
@@ -0,0 +1,157 @@
+package environments
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+// Categories of environments, to badge them and to apply the policy of each category
+const (
+	CategoryProduction  string = "production"
+	CategoryStaging     string = "staging"
+	CategoryDevelopment string = "development"
+	CategoryLab         string = "lab"
+	CategoryCustom      string = "custom"
+)
+
+// Categories to list all the categories of environments, in the order they are shown
+var Categories = []string{
+	CategoryProduction,
+	CategoryStaging,
+	CategoryDevelopment,
+	CategoryLab,
+	CategoryCustom,
+}
+
+// ErrConfirmName when a launch needs the name of the environment typed to confirm it
+var ErrConfirmName = errors.New("type the name of the environment to confirm")
+
+// ErrNoteRequired when a change of configuration needs a note
+var ErrNoteRequired = errors.New("a note is required to change the configuration")
+
+// CategoryPolicy to hold the policy applied to all the environments of a category
+type CategoryPolicy struct {
+	Category string `gorm:"primaryKey" json:"category"`
+	// Launches of queries and carves over this many targets need the name of the environment typed, 0 to disable
+	ConfirmTargets int `json:"confirm_targets"`
+	// Changes of configuration need a note
+	RequireNote bool `json:"require_note"`
+	// Default expiration in hours of the queries, for environments without their own
+	QueryExpiration int       `json:"query_expiration"`
+	UpdatedBy       string    `json:"updated_by"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// IsCategory to check if a category is valid, empty for environments without category
+func IsCategory(category string) bool {
+	if category == "" {
+		return true
+	}
+	for _, c := range Categories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultCategoryPolicy to get the policy of a category before admins change it
+func DefaultCategoryPolicy(category string) CategoryPolicy {
+	switch category {
+	case CategoryProduction:
+		return CategoryPolicy{Category: category, ConfirmTargets: 50, RequireNote: true, QueryExpiration: 24}
+	case CategoryStaging:
+		return CategoryPolicy{Category: category, ConfirmTargets: 200}
+	}
+	return CategoryPolicy{Category: category}
+}
+
+// CheckLaunch to check if a launch of a query or carve over a number of targets is confirmed, with the
+// name of the environment typed when the policy asks for it
+func (p CategoryPolicy) CheckLaunch(env TLSEnvironment, targets int, typed string) error {
+	if p.ConfirmTargets <= 0 || targets <= p.ConfirmTargets {
+		return nil
+	}
+	if strings.TrimSpace(typed) == env.Name {
+		return nil
+	}
+	return ErrConfirmName
+}
+
+// CheckNote to check if a change of configuration has the note the policy asks for
+func (p CategoryPolicy) CheckNote(note string) error {
+	if p.RequireNote && strings.TrimSpace(note) == "" {
+		return ErrNoteRequired
+	}
+	return nil
+}
+
+// Expiration to get the default expiration in hours of the queries of an environment, its own first
+// and then the one of the policy, 0 for the global default
+func (p CategoryPolicy) Expiration(env TLSEnvironment) int {
+	if env.QueryExpiration != 0 {
+		return env.QueryExpiration
+	}
+	return p.QueryExpiration
+}
+
+// PolicySummary to describe a policy of a category for the activity of its environments
+func PolicySummary(p CategoryPolicy) string {
+	return fmt.Sprintf("%s policy set to confirm launches over %d targets, require note %t, query expiration %dh", p.Category, p.ConfirmTargets, p.RequireNote, p.QueryExpiration)
+}
+
+// CategoryPolicy to get the policy of a category, the default one if admins did not change it
+func (environment *Environment) CategoryPolicy(category string) (CategoryPolicy, error) {
+	var policies []CategoryPolicy
+	if err := environment.read().Where("category = ?", category).Limit(1).Find(&policies).Error; err != nil {
+		return DefaultCategoryPolicy(category), fmt.Errorf("CategoryPolicy %v", err)
+	}
+	if len(policies) == 0 {
+		return DefaultCategoryPolicy(category), nil
+	}
+	return policies[0], nil
+}
+
+// CategoryPolicies to get the policies of all the categories
+func (environment *Environment) CategoryPolicies() ([]CategoryPolicy, error) {
+	var res []CategoryPolicy
+	for _, c := range Categories {
+		p, err := environment.CategoryPolicy(c)
+		if err != nil {
+			return res, err
+		}
+		res = append(res, p)
+	}
+	return res, nil
+}
+
+// SetCategoryPolicy to change the policy of a category
+func (environment *Environment) SetCategoryPolicy(p CategoryPolicy, user string) error {
+	if p.Category == "" || !IsCategory(p.Category) {
+		return fmt.Errorf("invalid category %s", p.Category)
+	}
+	if p.ConfirmTargets < 0 || p.QueryExpiration < 0 {
+		return fmt.Errorf("invalid policy for %s", p.Category)
+	}
+	p.UpdatedBy = user
+	p.UpdatedAt = time.Now()
+	if err := environment.DB.Clauses(clause.OnConflict{UpdateAll: true}).Create(&p).Error; err != nil {
+		return fmt.Errorf("SetCategoryPolicy %v", err)
+	}
+	return nil
+}
+
+// UpdateCategory to update the category of an environment, empty to remove it
+func (environment *Environment) UpdateCategory(idEnv, category string) error {
+	if !IsCategory(category) {
+		return fmt.Errorf("invalid category %s", category)
+	}
+	if err := environment.DB.Model(&TLSEnvironment{}).Where("name = ? OR uuid = ?", idEnv, idEnv).Update("category", category).Error; err != nil {
+		return fmt.Errorf("UpdateCategory %v", err)
+	}
+	return nil
+}
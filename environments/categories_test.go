@@ -0,0 +1,39 @@
+package environments
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsCategory(t *testing.T) {
+	assert.True(t, IsCategory(""))
+	assert.True(t, IsCategory(CategoryProduction))
+	assert.True(t, IsCategory(CategoryLab))
+	assert.False(t, IsCategory("prod"))
+}
+
+func TestCheckLaunch(t *testing.T) {
+	env := TLSEnvironment{Name: "corp"}
+	lab := DefaultCategoryPolicy(CategoryLab)
+	assert.NoError(t, lab.CheckLaunch(env, 5000, ""))
+	prod := DefaultCategoryPolicy(CategoryProduction)
+	assert.NoError(t, prod.CheckLaunch(env, 50, ""))
+	assert.ErrorIs(t, prod.CheckLaunch(env, 51, ""), ErrConfirmName)
+	assert.ErrorIs(t, prod.CheckLaunch(env, 51, "other"), ErrConfirmName)
+	assert.NoError(t, prod.CheckLaunch(env, 51, " corp "))
+}
+
+func TestCheckNote(t *testing.T) {
+	prod := DefaultCategoryPolicy(CategoryProduction)
+	assert.ErrorIs(t, prod.CheckNote("  "), ErrNoteRequired)
+	assert.NoError(t, prod.CheckNote("rotating packs"))
+	assert.NoError(t, DefaultCategoryPolicy(CategoryLab).CheckNote(""))
+}
+
+func TestPolicyExpiration(t *testing.T) {
+	prod := DefaultCategoryPolicy(CategoryProduction)
+	assert.Equal(t, 24, prod.Expiration(TLSEnvironment{}))
+	assert.Equal(t, 6, prod.Expiration(TLSEnvironment{QueryExpiration: 6}))
+	assert.Equal(t, 0, DefaultCategoryPolicy(CategoryDevelopment).Expiration(TLSEnvironment{}))
+}
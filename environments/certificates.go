@@ -0,0 +1,296 @@
+package environments
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/jmpsec/osctrl/backend"
+	"gorm.io/gorm"
+)
+
+const (
+	// Unique index of the versions of the certificate of each environment
+	certificateVersionIndex = "idx_env_certificate_version"
+	// Attempts to stage a certificate when another one takes the same version
+	certificateStageRetries = 3
+	// Time to keep the current certificate of an environment before reading it again
+	certificateCacheTTL = 60 * time.Second
+)
+
+// EnvCertificate to keep the versions of the certificate served to nodes of an environment
+type EnvCertificate struct {
+	gorm.Model
+	EnvironmentID uint `gorm:"index;uniqueIndex:idx_env_certificate_version"`
+	Version       int  `gorm:"uniqueIndex:idx_env_certificate_version"`
+	Certificate   string
+	Fingerprint   string
+	NotAfter      time.Time
+	ActivateAt    time.Time
+}
+
+// NodeCertificate to keep the certificate version that each node retrieved last
+type NodeCertificate struct {
+	gorm.Model
+	EnvironmentID uint   `gorm:"index"`
+	UUID          string `gorm:"index"`
+	Version       int
+	FetchedAt     time.Time
+}
+
+// CertificateStatus to report the propagation of the certificate of an environment
+type CertificateStatus struct {
+	Environment   string        `json:"environment"`
+	ActiveVersion int           `json:"active_version"`
+	StagedVersion int           `json:"staged_version"`
+	StagedAt      time.Time     `json:"staged_activate_at"`
+	Nodes         int64         `json:"nodes"`
+	Versions      map[int]int64 `json:"versions"`
+	Unknown       int64         `json:"unknown"`
+	Propagation   float64       `json:"propagation"`
+}
+
+// Current certificate of an environment, kept until it expires or a staged version is activated
+type cachedCertificate struct {
+	current EnvCertificate
+	until   time.Time
+}
+
+// ValidateCertificate to check that a PEM certificate parses, has not expired and matches the hostname.
+// CA certificates are accepted without hostname match, since they are used for pinning.
+func ValidateCertificate(certificate, hostname string) (*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	rest := []byte(certificate)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("ParseCertificate %v", err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no PEM certificate found")
+	}
+	if host, _, err := net.SplitHostPort(hostname); err == nil {
+		hostname = host
+	}
+	for _, cert := range certs {
+		if time.Now().After(cert.NotAfter) {
+			return nil, fmt.Errorf("certificate %s expired at %s", cert.Subject.CommonName, cert.NotAfter)
+		}
+		if cert.IsCA {
+			continue
+		}
+		if err := cert.VerifyHostname(hostname); err != nil {
+			return nil, err
+		}
+	}
+	return certs[0], nil
+}
+
+// Helper to generate the fingerprint of a certificate
+func certFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// StageCertificate to add a new version of the certificate, served to nodes from activateAt.
+// If activateAt is not in the future, the certificate is activated right away.
+func (environment *Environment) StageCertificate(env TLSEnvironment, certificate string, activateAt time.Time) (EnvCertificate, error) {
+	cert, err := ValidateCertificate(certificate, env.Hostname)
+	if err != nil {
+		return EnvCertificate{}, fmt.Errorf("invalid certificate - %v", err)
+	}
+	if activateAt.IsZero() {
+		activateAt = time.Now()
+	}
+	entry := EnvCertificate{
+		EnvironmentID: env.ID,
+		Certificate:   certificate,
+		Fingerprint:   certFingerprint(cert),
+		NotAfter:      cert.NotAfter,
+		ActivateAt:    activateAt,
+	}
+	for i := 0; ; i++ {
+		// Deleted versions are never reused
+		var latest EnvCertificate
+		if err := environment.DB.Unscoped().Where("environment_id = ?", env.ID).Order("version DESC").Limit(1).Find(&latest).Error; err != nil {
+			return EnvCertificate{}, fmt.Errorf("Find %v", err)
+		}
+		entry.ID = 0
+		entry.Version = latest.Version + 1
+		err := environment.DB.Create(&entry).Error
+		if err == nil {
+			break
+		}
+		// Another certificate took the same version
+		if !backend.IsConstraint(err) || i+1 >= certificateStageRetries {
+			return EnvCertificate{}, fmt.Errorf("Create %v", err)
+		}
+	}
+	environment.invalidateCertificate(env.ID)
+	if !activateAt.After(time.Now()) {
+		if err := environment.UpdateCertificate(env.UUID, certificate); err != nil {
+			return entry, err
+		}
+	}
+	return entry, nil
+}
+
+// GetCertificates to get all the certificate versions of an environment, newest first
+func (environment *Environment) GetCertificates(envid uint) ([]EnvCertificate, error) {
+	var certs []EnvCertificate
+	if err := environment.read().Where("environment_id = ?", envid).Order("version DESC").Find(&certs).Error; err != nil {
+		return certs, err
+	}
+	return certs, nil
+}
+
+// CurrentCertificate to get the certificate version to serve for an environment, and to cut over
+// to a staged version once it reaches its activation time. Environments without versions use version 0.
+// The version is kept in memory until the next staged version activates, or for a minute at most.
+func (environment *Environment) CurrentCertificate(env TLSEnvironment) (EnvCertificate, error) {
+	now := time.Now()
+	environment.certsMux.Lock()
+	cached, ok := environment.certs[env.ID]
+	environment.certsMux.Unlock()
+	if !ok || !now.Before(cached.until) {
+		var err error
+		if cached, err = environment.loadCertificate(env, now); err != nil {
+			return EnvCertificate{}, err
+		}
+	}
+	if cached.current.Version == 0 {
+		return EnvCertificate{EnvironmentID: env.ID, Certificate: env.Certificate}, nil
+	}
+	return cached.current, nil
+}
+
+// Helper to read the current certificate version of an environment and keep it in memory
+func (environment *Environment) loadCertificate(env TLSEnvironment, now time.Time) (cachedCertificate, error) {
+	var certs []EnvCertificate
+	if err := environment.DB.Where("environment_id = ?", env.ID).Order("version DESC").Find(&certs).Error; err != nil {
+		return cachedCertificate{}, fmt.Errorf("Find %v", err)
+	}
+	cached := cachedCertificate{until: now.Add(certificateCacheTTL)}
+	for _, c := range certs {
+		if c.ActivateAt.After(now) {
+			if c.ActivateAt.Before(cached.until) {
+				cached.until = c.ActivateAt
+			}
+			continue
+		}
+		if cached.current.Version == 0 {
+			cached.current = c
+		}
+	}
+	if cached.current.Version != 0 && cached.current.Certificate != env.Certificate {
+		if err := environment.UpdateCertificate(env.UUID, cached.current.Certificate); err != nil {
+			return cachedCertificate{}, err
+		}
+	}
+	environment.certsMux.Lock()
+	if environment.certs == nil {
+		environment.certs = make(map[uint]cachedCertificate)
+	}
+	environment.certs[env.ID] = cached
+	environment.certsMux.Unlock()
+	return cached, nil
+}
+
+// Helper to read the current certificate of an environment again the next time it is served
+func (environment *Environment) invalidateCertificate(envid uint) {
+	environment.certsMux.Lock()
+	delete(environment.certs, envid)
+	environment.certsMux.Unlock()
+}
+
+// Helper to number again the versions of the certificates staged before the unique index, so versions
+// taken twice by concurrent stages are kept in the order they were staged
+func migrateCertificates(backend *gorm.DB) error {
+	m := backend.Migrator()
+	if !m.HasTable(&EnvCertificate{}) || m.HasIndex(&EnvCertificate{}, certificateVersionIndex) {
+		return nil
+	}
+	return backend.Exec(`UPDATE env_certificates SET version = numbered.version FROM
+(SELECT id, ROW_NUMBER() OVER (PARTITION BY environment_id ORDER BY version, id) AS version FROM env_certificates) AS numbered
+WHERE env_certificates.id = numbered.id AND env_certificates.version <> numbered.version`).Error
+}
+
+// RecordCertificateFetch to keep the certificate version retrieved by a node
+func (environment *Environment) RecordCertificateFetch(envid uint, uuid string, version int) error {
+	uuid = strings.ToUpper(uuid)
+	var entry NodeCertificate
+	if err := environment.DB.Where("environment_id = ? AND uuid = ?", envid, uuid).Limit(1).Find(&entry).Error; err != nil {
+		return fmt.Errorf("Find %v", err)
+	}
+	if entry.ID == 0 {
+		entry = NodeCertificate{EnvironmentID: envid, UUID: uuid, Version: version, FetchedAt: time.Now()}
+		if err := environment.DB.Create(&entry).Error; err != nil {
+			return fmt.Errorf("Create %v", err)
+		}
+		return nil
+	}
+	if err := environment.DB.Model(&entry).Updates(map[string]interface{}{"version": version, "fetched_at": time.Now()}).Error; err != nil {
+		return fmt.Errorf("Updates %v", err)
+	}
+	return nil
+}
+
+// GetCertificateStatus to get how many of the nodes of an environment retrieved each certificate version
+func (environment *Environment) GetCertificateStatus(env TLSEnvironment, nodes int64) (CertificateStatus, error) {
+	status := CertificateStatus{
+		Environment: env.Name,
+		Nodes:       nodes,
+		Versions:    make(map[int]int64),
+	}
+	certs, err := environment.GetCertificates(env.ID)
+	if err != nil {
+		return status, fmt.Errorf("GetCertificates %v", err)
+	}
+	now := time.Now()
+	for _, c := range certs {
+		if c.ActivateAt.After(now) {
+			if status.StagedVersion == 0 {
+				status.StagedVersion = c.Version
+				status.StagedAt = c.ActivateAt
+			}
+			continue
+		}
+		status.ActiveVersion = c.Version
+		break
+	}
+	type versionCount struct {
+		Version int
+		Nodes   int64
+	}
+	var counts []versionCount
+	if err := environment.read().Model(&NodeCertificate{}).Select("version, count(*) as nodes").Where("environment_id = ?", env.ID).Group("version").Scan(&counts).Error; err != nil {
+		return status, fmt.Errorf("Group %v", err)
+	}
+	var known int64
+	for _, c := range counts {
+		status.Versions[c.Version] = c.Nodes
+		known += c.Nodes
+	}
+	if nodes > known {
+		status.Unknown = nodes - known
+	}
+	if nodes > 0 {
+		status.Propagation = float64(status.Versions[status.ActiveVersion]) * 100 / float64(nodes)
+	}
+	return status, nil
+}
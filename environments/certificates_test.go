@@ -0,0 +1,172 @@
+package environments
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// Helper to generate a self-signed PEM certificate
+func testCertificate(t *testing.T, host string, isCA bool, notAfter time.Time) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating key %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: host},
+		DNSNames:              []string{host},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              notAfter,
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("error creating certificate %v", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func TestValidateCertificate(t *testing.T) {
+	valid := time.Now().Add(24 * time.Hour)
+	t.Run("not PEM", func(t *testing.T) {
+		_, err := ValidateCertificate("certificate", "osctrl.example.com")
+		assert.Error(t, err)
+	})
+	t.Run("matching hostname", func(t *testing.T) {
+		cert, err := ValidateCertificate(testCertificate(t, "osctrl.example.com", false, valid), "osctrl.example.com:443")
+		assert.NoError(t, err)
+		assert.Equal(t, "osctrl.example.com", cert.Subject.CommonName)
+	})
+	t.Run("wrong hostname", func(t *testing.T) {
+		_, err := ValidateCertificate(testCertificate(t, "other.example.com", false, valid), "osctrl.example.com")
+		assert.Error(t, err)
+	})
+	t.Run("CA any hostname", func(t *testing.T) {
+		_, err := ValidateCertificate(testCertificate(t, "Internal CA", true, valid), "osctrl.example.com")
+		assert.NoError(t, err)
+	})
+	t.Run("expired", func(t *testing.T) {
+		_, err := ValidateCertificate(testCertificate(t, "osctrl.example.com", false, time.Now().Add(-time.Minute)), "osctrl.example.com")
+		assert.Error(t, err)
+	})
+}
+
+// Error of the database when a unique index is violated
+type uniqueViolation struct{}
+
+func (uniqueViolation) Error() string    { return "duplicate key value violates unique constraint" }
+func (uniqueViolation) SQLState() string { return "23505" }
+
+// Helper to get environments with a mocked postgres DB
+func mockCertificates(t *testing.T) (*Environment, sqlmock.Sqlmock) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db, got error: %v", err)
+	}
+	t.Cleanup(func() { mockDB.Close() })
+	_postgres, err := gorm.Open(postgres.New(postgres.Config{Conn: mockDB}), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("unable to create new postgres database: %v", err)
+	}
+	return &Environment{DB: _postgres}, mock
+}
+
+func TestStageCertificateVersionTaken(t *testing.T) {
+	e, mock := mockCertificates(t)
+	env := TLSEnvironment{Model: gorm.Model{ID: 1}, UUID: "ENV-UUID", Hostname: "osctrl.example.com"}
+	cert := testCertificate(t, "osctrl.example.com", false, time.Now().Add(24*time.Hour))
+	latest := `SELECT \* FROM "env_certificates" WHERE environment_id = \$1 ORDER BY version DESC LIMIT 1`
+	insert := `INSERT INTO "env_certificates"`
+	// Another certificate is staged with version 3 at the same time, this one takes version 4
+	mock.ExpectQuery(latest).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id", "environment_id", "version"}).AddRow(2, 1, 2))
+	mock.ExpectBegin()
+	mock.ExpectQuery(insert).WillReturnError(uniqueViolation{})
+	mock.ExpectRollback()
+	mock.ExpectQuery(latest).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id", "environment_id", "version"}).AddRow(3, 1, 3))
+	mock.ExpectBegin()
+	mock.ExpectQuery(insert).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, 1, 4, cert, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+	mock.ExpectCommit()
+	staged, err := e.StageCertificate(env, cert, time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 4, staged.Version)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestStageCertificateNotRetried(t *testing.T) {
+	e, mock := mockCertificates(t)
+	env := TLSEnvironment{Model: gorm.Model{ID: 1}, UUID: "ENV-UUID", Hostname: "osctrl.example.com"}
+	cert := testCertificate(t, "osctrl.example.com", false, time.Now().Add(24*time.Hour))
+	mock.ExpectQuery(`SELECT \* FROM "env_certificates"`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "env_certificates"`).WillReturnError(sqlmock.ErrCancelled)
+	mock.ExpectRollback()
+	_, err := e.StageCertificate(env, cert, time.Now().Add(time.Hour))
+	assert.Error(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCurrentCertificateCached(t *testing.T) {
+	e, mock := mockCertificates(t)
+	env := TLSEnvironment{Model: gorm.Model{ID: 1}, UUID: "ENV-UUID", Certificate: "cert-2"}
+	activate := time.Now().Add(10 * time.Second)
+	mock.ExpectQuery(`SELECT \* FROM "env_certificates" WHERE environment_id = \$1 AND "env_certificates"."deleted_at" IS NULL ORDER BY version DESC`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "environment_id", "version", "certificate", "activate_at"}).
+			AddRow(3, 1, 3, "cert-3", activate).
+			AddRow(2, 1, 2, "cert-2", time.Now().Add(-time.Hour)).
+			AddRow(1, 1, 1, "cert-1", time.Now().Add(-2*time.Hour)))
+	// Only the first certificate served reads the versions
+	for i := 0; i < 3; i++ {
+		current, err := e.CurrentCertificate(env)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, current.Version)
+		assert.Equal(t, "cert-2", current.Certificate)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+	// The staged version is read again once it activates
+	assert.True(t, e.certs[1].until.Equal(activate))
+}
+
+func TestCurrentCertificateCutOver(t *testing.T) {
+	e, mock := mockCertificates(t)
+	env := TLSEnvironment{Model: gorm.Model{ID: 1}, UUID: "ENV-UUID", Certificate: "cert-1"}
+	mock.ExpectQuery(`SELECT \* FROM "env_certificates"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "environment_id", "version", "certificate", "activate_at"}).
+			AddRow(2, 1, 2, "cert-2", time.Now().Add(-time.Minute)))
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "tls_environments" SET "certificate"=\$1`).WithArgs("cert-2", sqlmock.AnyArg(), "ENV-UUID", "ENV-UUID").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	current, err := e.CurrentCertificate(env)
+	assert.NoError(t, err)
+	assert.Equal(t, "cert-2", current.Certificate)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCurrentCertificateWithoutVersions(t *testing.T) {
+	e, mock := mockCertificates(t)
+	mock.ExpectQuery(`SELECT \* FROM "env_certificates"`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	current, err := e.CurrentCertificate(TLSEnvironment{Model: gorm.Model{ID: 1}, Certificate: "cert-0"})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, current.Version)
+	assert.Equal(t, "cert-0", current.Certificate)
+	// Environments without versions serve their certificate as it changes
+	current, err = e.CurrentCertificate(TLSEnvironment{Model: gorm.Model{ID: 1}, Certificate: "cert-new"})
+	assert.NoError(t, err)
+	assert.Equal(t, "cert-new", current.Certificate)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,392 @@
+package environments
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Conflicts in the configuration are resolved with the following precedence, independent of the order of keys:
+//   - Options of the environment win over the options of packs, packs win between them in the order of their names.
+//   - Queries in the schedule win over pack queries osquery names the same, pack_<pack>_<query>, packs win between
+//     them in the order of their names.
+// The resolved configuration only drops what loses, everything else is served as it is.
+
+const (
+	// ConflictDuplicateQuery is a query name defined in more than one place of the schedule and packs
+	ConflictDuplicateQuery = "duplicate_query"
+	// ConflictQueryName is a scheduled query name that osquery generates from more than one query
+	ConflictQueryName = "query_name"
+	// ConflictOption is an option with different values in the environment and packs
+	ConflictOption = "option"
+	// ConflictPlatform is a pack query that never runs because of the platform of its pack
+	ConflictPlatform = "platform"
+	// ConflictBudget is a set of queries running together above the query budget of nodes
+	ConflictBudget = "query_budget"
+	// ConflictsOff to not check conflicts when configurations are saved
+	ConflictsOff = "off"
+	// ConflictsWarn to save configurations with conflicts, returning them as warnings
+	ConflictsWarn = "warn"
+	// ConflictsError to reject configurations with conflicts
+	ConflictsError = "error"
+	// DefaultQueryBudget is the default number of scheduled queries that can run together in one node
+	DefaultQueryBudget = 25
+)
+
+// ConflictPlatforms are the platforms checked for scheduled queries running together
+var ConflictPlatforms = []string{"darwin", "linux", "windows"}
+
+// ConfigConflict to hold one conflict in the configuration of an environment, with the locations involved in
+// order of precedence, like options.<key>, schedule.<query> or packs.<pack>.queries.<query>
+type ConfigConflict struct {
+	Kind      string   `json:"kind"`
+	Locations []string `json:"locations"`
+	Message   string   `json:"message"`
+}
+
+// String to format a conflict for warnings
+func (c ConfigConflict) String() string {
+	return c.Message + " (" + strings.Join(c.Locations, ", ") + ")"
+}
+
+// conflictPack to hold the parts of a pack that can conflict
+type conflictPack struct {
+	Queries  map[string]ScheduleQuery `json:"queries"`
+	Platform string                   `json:"platform"`
+	Options  map[string]interface{}   `json:"options"`
+}
+
+// conflictQuery to hold one scheduled or pack query with its location
+type conflictQuery struct {
+	Location string
+	Name     string
+	Pack     string
+	Query    ScheduleQuery
+}
+
+// conflictConf to hold the parsed parts of a configuration that can conflict
+type conflictConf struct {
+	Options  map[string]interface{}
+	Schedule map[string]ScheduleQuery
+	Packs    map[string]conflictPack
+}
+
+// Helper to unmarshal one part of the configuration, empty parts are skipped
+func unmarshalPart(data []byte, v interface{}) error {
+	if s := strings.TrimSpace(string(data)); s == "" || s == "null" {
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Helper to parse the parts of a configuration that can conflict, local packs are skipped
+func parseConflictConf(options, schedule, packs []byte) (conflictConf, error) {
+	var c conflictConf
+	if err := unmarshalPart(options, &c.Options); err != nil {
+		return c, fmt.Errorf("Unmarshal options %v", err)
+	}
+	if err := unmarshalPart(schedule, &c.Schedule); err != nil {
+		return c, fmt.Errorf("Unmarshal schedule %v", err)
+	}
+	var raw map[string]json.RawMessage
+	if err := unmarshalPart(packs, &raw); err != nil {
+		return c, fmt.Errorf("Unmarshal packs %v", err)
+	}
+	c.Packs = make(map[string]conflictPack)
+	for name, p := range raw {
+		// Local packs are only a path
+		var path string
+		if json.Unmarshal(p, &path) == nil {
+			continue
+		}
+		var pack conflictPack
+		if err := json.Unmarshal(p, &pack); err != nil {
+			return c, fmt.Errorf("Unmarshal pack %s %v", name, err)
+		}
+		c.Packs[name] = pack
+	}
+	return c, nil
+}
+
+// Helper to get the keys of a map with string keys sorted
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	for _, k := range reflect.ValueOf(m).MapKeys() {
+		keys = append(keys, k.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Helper to get all the scheduled and pack queries, in order of precedence
+func (c conflictConf) queries() []conflictQuery {
+	var res []conflictQuery
+	for _, name := range sortedKeys(c.Schedule) {
+		res = append(res, conflictQuery{Location: "schedule." + name, Name: name, Query: c.Schedule[name]})
+	}
+	for _, p := range sortedKeys(c.Packs) {
+		for _, name := range sortedKeys(c.Packs[p].Queries) {
+			res = append(res, conflictQuery{Location: "packs." + p + ".queries." + name, Name: name, Pack: p, Query: c.Packs[p].Queries[name]})
+		}
+	}
+	return res
+}
+
+// Helper to get the name osquery uses for a query
+func (q conflictQuery) scheduledName() string {
+	if q.Pack == "" {
+		return q.Name
+	}
+	return PackQueryName(q.Pack, q.Name)
+}
+
+// Helper to check if a query runs in a platform, with the platform of its pack
+func (c conflictConf) runsIn(q conflictQuery, platform string) bool {
+	if q.Pack != "" && !IsPlatformQuery(strings.ToLower(c.Packs[q.Pack].Platform), platform) {
+		return false
+	}
+	return IsPlatformQuery(strings.ToLower(q.Query.Platform), platform)
+}
+
+// Helper to get the pack queries and pack options that lose by precedence, by pack
+func (c conflictConf) losers() (map[string][]string, map[string][]string) {
+	queries := make(map[string][]string)
+	names := make(map[string]bool)
+	for _, q := range c.queries() {
+		name := q.scheduledName()
+		if names[name] && q.Pack != "" {
+			queries[q.Pack] = append(queries[q.Pack], q.Name)
+		}
+		names[name] = true
+	}
+	options := make(map[string][]string)
+	winners := make(map[string]interface{})
+	for k, v := range c.Options {
+		winners[k] = v
+	}
+	for _, p := range sortedKeys(c.Packs) {
+		for _, k := range sortedKeys(c.Packs[p].Options) {
+			v := c.Packs[p].Options[k]
+			if w, ok := winners[k]; ok {
+				if !reflect.DeepEqual(w, v) {
+					options[p] = append(options[p], k)
+				}
+				continue
+			}
+			winners[k] = v
+		}
+	}
+	return queries, options
+}
+
+// Helper to detect the conflicts of parsed parts of a configuration, a budget of 0 does not check queries running together
+func (c conflictConf) conflicts(budget int) []ConfigConflict {
+	var res []ConfigConflict
+	all := c.queries()
+	// Same query name in several places, and same name generated for osquery
+	byName := make(map[string][]string)
+	byScheduled := make(map[string][]string)
+	var names, scheduled []string
+	for _, q := range all {
+		if _, ok := byName[q.Name]; !ok {
+			names = append(names, q.Name)
+		}
+		byName[q.Name] = append(byName[q.Name], q.Location)
+		if _, ok := byScheduled[q.scheduledName()]; !ok {
+			scheduled = append(scheduled, q.scheduledName())
+		}
+		byScheduled[q.scheduledName()] = append(byScheduled[q.scheduledName()], q.Location)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		if locations := byName[n]; len(locations) > 1 {
+			res = append(res, ConfigConflict{
+				Kind:      ConflictDuplicateQuery,
+				Locations: locations,
+				Message:   fmt.Sprintf("query %s is defined %d times", n, len(locations)),
+			})
+		}
+	}
+	sort.Strings(scheduled)
+	for _, n := range scheduled {
+		if locations := byScheduled[n]; len(locations) > 1 {
+			res = append(res, ConfigConflict{
+				Kind:      ConflictQueryName,
+				Locations: locations,
+				Message:   fmt.Sprintf("osquery schedules %d queries as %s, only %s is served", len(locations), n, locations[0]),
+			})
+		}
+	}
+	// Options with different values, the environment wins and then packs by name
+	options := make(map[string][]string)
+	values := make(map[string][]interface{})
+	for k, v := range c.Options {
+		options[k] = []string{"options." + k}
+		values[k] = []interface{}{v}
+	}
+	for _, p := range sortedKeys(c.Packs) {
+		for _, k := range sortedKeys(c.Packs[p].Options) {
+			options[k] = append(options[k], "packs."+p+".options."+k)
+			values[k] = append(values[k], c.Packs[p].Options[k])
+		}
+	}
+	for _, k := range sortedKeys(options) {
+		for _, v := range values[k][1:] {
+			if !reflect.DeepEqual(values[k][0], v) {
+				res = append(res, ConfigConflict{
+					Kind:      ConflictOption,
+					Locations: options[k],
+					Message:   fmt.Sprintf("option %s has different values, %v from %s is served", k, values[k][0], options[k][0]),
+				})
+				break
+			}
+		}
+	}
+	// Pack queries for platforms their pack never runs in
+	for _, q := range all {
+		if q.Pack == "" || q.Query.Platform == "" || c.Packs[q.Pack].Platform == "" {
+			continue
+		}
+		runs := false
+		for _, platform := range append(ConflictPlatforms, "freebsd") {
+			if c.runsIn(q, platform) {
+				runs = true
+				break
+			}
+		}
+		if !runs {
+			res = append(res, ConfigConflict{
+				Kind:      ConflictPlatform,
+				Locations: []string{q.Location, "packs." + q.Pack + ".platform"},
+				Message:   fmt.Sprintf("query %s is for %s but pack %s is for %s, it never runs", q.Name, q.Query.Platform, q.Pack, c.Packs[q.Pack].Platform),
+			})
+		}
+	}
+	if budget <= 0 {
+		return res
+	}
+	// Queries running together, a query runs every time its interval is up during one day or its longest interval
+	for _, platform := range ConflictPlatforms {
+		var running []conflictQuery
+		var intervals []int64
+		horizon := int64(secondsPerDay)
+		for _, q := range all {
+			interval, err := q.Query.Interval.Int64()
+			if err != nil || interval <= 0 || !c.runsIn(q, platform) {
+				continue
+			}
+			running = append(running, q)
+			intervals = append(intervals, interval)
+			if interval > horizon {
+				horizon = interval
+			}
+		}
+		counts := make(map[int64]int)
+		var worst int
+		var worstSecond int64
+		for _, i := range intervals {
+			for t := i; t <= horizon; t += i {
+				counts[t]++
+				if counts[t] > worst || (counts[t] == worst && t < worstSecond) {
+					worst, worstSecond = counts[t], t
+				}
+			}
+		}
+		if worst <= budget {
+			continue
+		}
+		var together []string
+		for j, q := range running {
+			if worstSecond%intervals[j] == 0 {
+				together = append(together, q.Location)
+			}
+		}
+		res = append(res, ConfigConflict{
+			Kind:      ConflictBudget,
+			Locations: together,
+			Message:   fmt.Sprintf("%d queries run together every %d seconds in %s nodes, above the budget of %d", worst, worstSecond, platform, budget),
+		})
+	}
+	return res
+}
+
+// DetectConflicts to get the conflicts between the options, schedule and packs of an environment.
+// A budget of 0 does not check the scheduled queries running together in nodes.
+func DetectConflicts(env TLSEnvironment, budget int) ([]ConfigConflict, error) {
+	c, err := parseConflictConf([]byte(env.Options), []byte(env.Schedule), []byte(env.Packs))
+	if err != nil {
+		return []ConfigConflict{}, err
+	}
+	conflicts := c.conflicts(budget)
+	if conflicts == nil {
+		conflicts = []ConfigConflict{}
+	}
+	return conflicts, nil
+}
+
+// ValidateConflicts to check the conflicts of a configuration before saving it, with the strictness of the settings.
+// Conflicts are returned as warnings, or as an error when the strictness is error. Unknown strictness is handled as warn.
+func ValidateConflicts(env TLSEnvironment, strictness string, budget int) ([]string, error) {
+	var warnings []string
+	if strictness == ConflictsOff {
+		return warnings, nil
+	}
+	conflicts, err := DetectConflicts(env, budget)
+	if err != nil {
+		return warnings, err
+	}
+	for _, c := range conflicts {
+		warnings = append(warnings, c.String())
+	}
+	if strictness == ConflictsError && len(warnings) > 0 {
+		return warnings, fmt.Errorf("configuration has conflicts: %s", strings.Join(warnings, "; "))
+	}
+	return warnings, nil
+}
+
+// ResolveConflicts to drop from a serialized configuration the pack queries and pack options that lose by precedence.
+// The configuration is returned as it is when there is nothing to resolve.
+func ResolveConflicts(configuration []byte) ([]byte, error) {
+	var conf map[string]json.RawMessage
+	if err := unmarshalPart(configuration, &conf); err != nil {
+		return configuration, fmt.Errorf("Unmarshal %v", err)
+	}
+	c, err := parseConflictConf(conf["options"], conf["schedule"], conf["packs"])
+	if err != nil {
+		return configuration, err
+	}
+	queries, options := c.losers()
+	if len(queries) == 0 && len(options) == 0 {
+		return configuration, nil
+	}
+	var packs map[string]interface{}
+	if err := json.Unmarshal(conf["packs"], &packs); err != nil {
+		return configuration, fmt.Errorf("Unmarshal packs %v", err)
+	}
+	for p, names := range queries {
+		entry, _ := packs[p].(map[string]interface{})
+		if q, ok := entry["queries"].(map[string]interface{}); ok {
+			for _, n := range names {
+				delete(q, n)
+			}
+		}
+	}
+	for p, keys := range options {
+		entry, _ := packs[p].(map[string]interface{})
+		if o, ok := entry["options"].(map[string]interface{}); ok {
+			for _, k := range keys {
+				delete(o, k)
+			}
+		}
+	}
+	if conf["packs"], err = json.Marshal(packs); err != nil {
+		return configuration, fmt.Errorf("Marshal packs %v", err)
+	}
+	res, err := json.MarshalIndent(conf, "", "  ")
+	if err != nil {
+		return configuration, fmt.Errorf("Marshal %v", err)
+	}
+	return res, nil
+}
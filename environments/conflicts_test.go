@@ -0,0 +1,155 @@
+package environments
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testConflictPacks = `{
+  "local": "/etc/osquery/packs/local.conf",
+  "b_pack": {
+    "options": {"events_expiry": 600, "disable_events": false},
+    "queries": {
+      "uptime": {"query": "SELECT * FROM uptime;", "interval": 60},
+      "c": {"query": "SELECT 2;", "interval": 120}
+    }
+  },
+  "b": {
+    "platform": "darwin",
+    "options": {"events_expiry": 300, "disable_events": true},
+    "queries": {
+      "pack_c": {"query": "SELECT 1;", "interval": 60},
+      "services": {"query": "SELECT * FROM services;", "interval": 60, "platform": "windows"}
+    }
+  }
+}`
+
+func testConflictEnv() TLSEnvironment {
+	return TLSEnvironment{
+		Options:  `{"events_expiry": 3600, "host_identifier": "uuid"}`,
+		Schedule: `{"uptime": {"query": "SELECT * FROM uptime;", "interval": 60}, "pack_b_pack_uptime": {"query": "SELECT 3;", "interval": 30}}`,
+		Packs:    testConflictPacks,
+	}
+}
+
+func TestDetectConflicts(t *testing.T) {
+	conflicts, err := DetectConflicts(testConflictEnv(), 0)
+	assert.NoError(t, err)
+	kinds := make(map[string][]ConfigConflict)
+	for _, c := range conflicts {
+		kinds[c.Kind] = append(kinds[c.Kind], c)
+	}
+	assert.Len(t, kinds[ConflictDuplicateQuery], 1)
+	assert.Equal(t, []string{"schedule.uptime", "packs.b_pack.queries.uptime"}, kinds[ConflictDuplicateQuery][0].Locations)
+	// Generated names collide with the schedule and between packs
+	assert.Len(t, kinds[ConflictQueryName], 2)
+	assert.Equal(t, []string{"packs.b.queries.pack_c", "packs.b_pack.queries.c"}, kinds[ConflictQueryName][0].Locations)
+	assert.Equal(t, []string{"schedule.pack_b_pack_uptime", "packs.b_pack.queries.uptime"}, kinds[ConflictQueryName][1].Locations)
+	assert.Len(t, kinds[ConflictOption], 2)
+	assert.Equal(t, []string{"packs.b.options.disable_events", "packs.b_pack.options.disable_events"}, kinds[ConflictOption][0].Locations)
+	assert.Equal(t, []string{"options.events_expiry", "packs.b.options.events_expiry", "packs.b_pack.options.events_expiry"}, kinds[ConflictOption][1].Locations)
+	assert.Len(t, kinds[ConflictPlatform], 1)
+	assert.Equal(t, "packs.b.queries.services", kinds[ConflictPlatform][0].Locations[0])
+	assert.Len(t, kinds[ConflictBudget], 0)
+	// Detection is deterministic
+	for i := 0; i < 10; i++ {
+		again, err := DetectConflicts(testConflictEnv(), 0)
+		assert.NoError(t, err)
+		assert.Equal(t, conflicts, again)
+	}
+	// Without conflicts
+	conflicts, err = DetectConflicts(TLSEnvironment{Options: `{"events_expiry": 3600}`}, DefaultQueryBudget)
+	assert.NoError(t, err)
+	assert.Len(t, conflicts, 0)
+	_, err = DetectConflicts(TLSEnvironment{Packs: `{"a": []}`}, 0)
+	assert.Error(t, err)
+}
+
+func TestDetectConflictsBudget(t *testing.T) {
+	env := TLSEnvironment{
+		Schedule: `{"q1": {"interval": 60}, "q2": {"interval": 120}, "q3": {"interval": 180}, "q4": {"interval": 7, "platform": "windows"}}`,
+	}
+	// At 360 seconds q1, q2 and q3 run together, at 2520 seconds q4 too in windows
+	conflicts, err := DetectConflicts(env, 2)
+	assert.NoError(t, err)
+	assert.Len(t, conflicts, 3)
+	assert.Equal(t, ConflictBudget, conflicts[0].Kind)
+	assert.Equal(t, []string{"schedule.q1", "schedule.q2", "schedule.q3"}, conflicts[0].Locations)
+	assert.Contains(t, conflicts[0].Message, "every 360 seconds in darwin")
+	assert.Contains(t, conflicts[1].Message, "in linux")
+	assert.Equal(t, "4 queries run together every 2520 seconds in windows nodes, above the budget of 2", conflicts[2].Message)
+	conflicts, err = DetectConflicts(env, 3)
+	assert.NoError(t, err)
+	assert.Len(t, conflicts, 1)
+	conflicts, err = DetectConflicts(env, 4)
+	assert.NoError(t, err)
+	assert.Len(t, conflicts, 0)
+}
+
+func TestValidateConflicts(t *testing.T) {
+	env := testConflictEnv()
+	warnings, err := ValidateConflicts(env, ConflictsOff, 0)
+	assert.NoError(t, err)
+	assert.Len(t, warnings, 0)
+	warnings, err = ValidateConflicts(env, ConflictsWarn, 0)
+	assert.NoError(t, err)
+	assert.Len(t, warnings, 6)
+	assert.Equal(t, "query uptime is defined 2 times (schedule.uptime, packs.b_pack.queries.uptime)", warnings[0])
+	_, err = ValidateConflicts(env, ConflictsError, 0)
+	assert.Error(t, err)
+	_, err = ValidateConflicts(TLSEnvironment{}, ConflictsError, 0)
+	assert.NoError(t, err)
+}
+
+func TestResolveConflicts(t *testing.T) {
+	env := testConflictEnv()
+	conf := `{"options":` + env.Options + `,"schedule":` + env.Schedule + `,"packs":` + env.Packs + `}`
+	resolved, err := ResolveConflicts([]byte(conf))
+	assert.NoError(t, err)
+	var parsed struct {
+		Options  map[string]interface{}            `json:"options"`
+		Schedule map[string]map[string]interface{} `json:"schedule"`
+		Packs    map[string]interface{}            `json:"packs"`
+	}
+	assert.NoError(t, json.Unmarshal(resolved, &parsed))
+	// The environment wins for options, then packs by name
+	assert.Equal(t, float64(3600), parsed.Options["events_expiry"])
+	a := parsed.Packs["b"].(map[string]interface{})
+	b := parsed.Packs["b_pack"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"disable_events": true}, a["options"])
+	assert.Equal(t, map[string]interface{}{}, b["options"])
+	// The schedule wins for names, then packs by name
+	assert.Len(t, parsed.Schedule, 2)
+	assert.Len(t, a["queries"], 2)
+	assert.Equal(t, map[string]interface{}{}, b["queries"])
+	assert.Equal(t, "/etc/osquery/packs/local.conf", parsed.Packs["local"])
+	// Resolution does not depend on the order of keys
+	reordered := `{"packs":{"b":{"queries":{"services":{"query":"SELECT * FROM services;","interval":60,"platform":"windows"},"pack_c":{"query":"SELECT 1;","interval":60}},"options":{"disable_events":true,"events_expiry":300},"platform":"darwin"},"local":"/etc/osquery/packs/local.conf","b_pack":{"queries":{"c":{"query":"SELECT 2;","interval":120},"uptime":{"query":"SELECT * FROM uptime;","interval":60}},"options":{"disable_events":false,"events_expiry":600}}},"schedule":` + env.Schedule + `,"options":` + env.Options + `}`
+	again, err := ResolveConflicts([]byte(reordered))
+	assert.NoError(t, err)
+	assert.JSONEq(t, string(resolved), string(again))
+	// Nothing to resolve keeps the configuration as it is
+	clean := []byte(`{"options":{"events_expiry":3600},"packs":{"a":{"options":{"events_expiry":3600}}}}`)
+	same, err := ResolveConflicts(clean)
+	assert.NoError(t, err)
+	assert.Equal(t, clean, same)
+	_, err = ResolveConflicts([]byte(`{"options":`))
+	assert.Error(t, err)
+}
+
+func TestNodeConfigurationConflicts(t *testing.T) {
+	env := TLSEnvironment{Configuration: `{"schedule":{"pack_p_uptime":{"query":"SELECT 1;","interval":60}},"packs":{"p":{"queries":{"uptime":{"query":"SELECT 2;","interval":60}}},"p_uptime":{"queries":{"x":{"query":"SELECT 3;","interval":60}}}}}`}
+	overrides := []ScheduleOverride{{UUID: "AAA", Query: "pack_p_uptime_x", Interval: 10}}
+	conf, _, err := NodeConfiguration(env, nil, nil, nil, overrides, ProfileNode{Platform: "linux"})
+	assert.NoError(t, err)
+	var parsed struct {
+		Packs map[string]struct {
+			Queries map[string]map[string]interface{} `json:"queries"`
+		} `json:"packs"`
+	}
+	assert.NoError(t, json.Unmarshal(conf, &parsed))
+	assert.Len(t, parsed.Packs["p"].Queries, 0)
+	assert.Equal(t, float64(10), parsed.Packs["p_uptime"].Queries["x"]["interval"])
+}
@@ -1,5 +1,10 @@
 package environments
 
+import (
+	"encoding/json"
+	"fmt"
+)
+
 const (
 	// DecoratorUsers to append osquery user as result decorator
 	DecoratorUsers = "SELECT username AS osquery_user FROM users WHERE uid = (SELECT uid FROM processes WHERE pid = (SELECT pid FROM osquery_info) LIMIT 1);"
@@ -11,4 +16,45 @@ const (
 	DecoratorOsqueryVersionHash = "SELECT version AS osquery_version, config_hash FROM osquery_info WHERE config_valid = 1;"
 	// DecoratorMD5Process to append the MD5 of the running osquery binary as result decorator
 	DecoratorMD5Process = "SELECT md5 AS osquery_md5 FROM hash WHERE path = (SELECT path FROM processes WHERE pid = (SELECT pid FROM osquery_info));"
+	// DecoratorIdentity to append the characteristics of the host used for identity binding as result decorator
+	DecoratorIdentity = "SELECT uuid AS hardware_uuid, cpu_brand, (SELECT platform FROM os_version) AS os_platform, (SELECT group_concat(mac, ',') FROM (SELECT DISTINCT mac FROM interface_details WHERE mac != '' AND mac != '00:00:00:00:00:00')) AS mac_addresses FROM system_info;"
 )
+
+// IdentityDecoratorConfig to add the identity decorator to the always decorators of a serialized configuration,
+// for environments that bind the identity of nodes
+func IdentityDecoratorConfig(configuration []byte) ([]byte, error) {
+	var conf map[string]json.RawMessage
+	if err := json.Unmarshal(configuration, &conf); err != nil {
+		return configuration, fmt.Errorf("Unmarshal %v", err)
+	}
+	decorators := make(map[string]json.RawMessage)
+	if raw, ok := conf["decorators"]; ok && string(raw) != "null" {
+		if err := json.Unmarshal(raw, &decorators); err != nil {
+			return configuration, fmt.Errorf("Unmarshal decorators %v", err)
+		}
+	}
+	var always []string
+	if raw, ok := decorators["always"]; ok && string(raw) != "null" {
+		if err := json.Unmarshal(raw, &always); err != nil {
+			return configuration, fmt.Errorf("Unmarshal always %v", err)
+		}
+	}
+	for _, d := range always {
+		if d == DecoratorIdentity {
+			return configuration, nil
+		}
+	}
+	always = append(always, DecoratorIdentity)
+	var err error
+	if decorators["always"], err = json.Marshal(always); err != nil {
+		return configuration, fmt.Errorf("Marshal always %v", err)
+	}
+	if conf["decorators"], err = json.Marshal(decorators); err != nil {
+		return configuration, fmt.Errorf("Marshal decorators %v", err)
+	}
+	res, err := json.MarshalIndent(conf, "", "  ")
+	if err != nil {
+		return configuration, fmt.Errorf("Marshal %v", err)
+	}
+	return res, nil
+}
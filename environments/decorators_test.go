@@ -0,0 +1,34 @@
+package environments
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdentityDecoratorConfig(t *testing.T) {
+	t.Run("added to existing decorators", func(t *testing.T) {
+		conf := []byte(`{"options": {"host_identifier": "uuid"}, "decorators": {"always": ["` + DecoratorHostname + `"], "interval": {"3600": ["SELECT 1;"]}}}`)
+		res, err := IdentityDecoratorConfig(conf)
+		assert.NoError(t, err)
+		var parsed OsqueryConf
+		assert.NoError(t, json.Unmarshal(res, &parsed))
+		assert.Equal(t, []string{DecoratorHostname, DecoratorIdentity}, parsed.Decorators.Always)
+		assert.Equal(t, "uuid", parsed.Options["host_identifier"])
+		again, err := IdentityDecoratorConfig(res)
+		assert.NoError(t, err)
+		assert.Equal(t, res, again)
+	})
+	t.Run("configuration without decorators", func(t *testing.T) {
+		res, err := IdentityDecoratorConfig([]byte(`{"schedule": {}}`))
+		assert.NoError(t, err)
+		var parsed OsqueryConf
+		assert.NoError(t, json.Unmarshal(res, &parsed))
+		assert.Equal(t, []string{DecoratorIdentity}, parsed.Decorators.Always)
+	})
+	t.Run("invalid configuration", func(t *testing.T) {
+		_, err := IdentityDecoratorConfig([]byte(`{"decorators": []}`))
+		assert.Error(t, err)
+	})
+}
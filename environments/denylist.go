@@ -0,0 +1,284 @@
+package environments
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ScheduleDeny to remove a scheduled or pack query from the configurations served to the nodes of an environment,
+// regardless of which pack or schedule section defines it. Entries with a pack and no query remove the whole pack,
+// entries with a query and no pack remove that query from the schedule and from every pack. Platform and tag limit
+// the nodes the entry applies to. Entries without expiration are active until they are deleted.
+type ScheduleDeny struct {
+	gorm.Model
+	EnvironmentID uint `gorm:"index"`
+	Pack          string
+	Query         string
+	Platform      string
+	Tag           string
+	Reason        string
+	Creator       string
+	ExpiresAt     time.Time
+}
+
+// DenyCandidate to hold a query or a pack of a configuration that can be added to the denylist
+type DenyCandidate struct {
+	Pack  string `json:"pack"`
+	Query string `json:"query"`
+}
+
+// ValidateScheduleDeny to check a denylist entry has a pack or query, a reason and an expiration in the future
+func ValidateScheduleDeny(entry ScheduleDeny, now time.Time) error {
+	if strings.TrimSpace(entry.Pack) == "" && strings.TrimSpace(entry.Query) == "" {
+		return fmt.Errorf("denylist entry needs a pack or a query")
+	}
+	if strings.TrimSpace(entry.Reason) == "" {
+		return fmt.Errorf("denylist entry needs a reason")
+	}
+	if !entry.ExpiresAt.IsZero() && !entry.ExpiresAt.After(now) {
+		return fmt.Errorf("expiration must be in the future")
+	}
+	return nil
+}
+
+// Active to check if a denylist entry did not expire yet
+func (entry ScheduleDeny) Active(now time.Time) bool {
+	return entry.ExpiresAt.IsZero() || entry.ExpiresAt.After(now)
+}
+
+// Target to get what a denylist entry removes, as it is displayed and recorded in the activity
+func (entry ScheduleDeny) Target() string {
+	switch {
+	case entry.Query == "":
+		return "pack " + entry.Pack
+	case entry.Pack == "":
+		return "query " + entry.Query
+	}
+	return "query " + entry.Query + " in pack " + entry.Pack
+}
+
+// Summary to describe a denylist entry with its scope, expiration and reason, as it is recorded in the activity
+func (entry ScheduleDeny) Summary() string {
+	summary := entry.Target()
+	if entry.Platform != "" {
+		summary += " for platform " + entry.Platform
+	}
+	if entry.Tag != "" {
+		summary += " for tag " + entry.Tag
+	}
+	if !entry.ExpiresAt.IsZero() {
+		summary += " until " + entry.ExpiresAt.Format(time.RFC3339)
+	}
+	return summary + ": " + entry.Reason
+}
+
+// AppliesTo to check if a denylist entry applies to a node, by its platform and tags
+func (entry ScheduleDeny) AppliesTo(node ProfileNode) bool {
+	if entry.Platform != "" && !strings.EqualFold(entry.Platform, node.Platform) {
+		return false
+	}
+	if entry.Tag == "" {
+		return true
+	}
+	for _, t := range node.Tags {
+		if t == entry.Tag {
+			return true
+		}
+	}
+	return false
+}
+
+// DenylistUsesTags to check if any of the denylist entries is scoped with tags
+func DenylistUsesTags(entries []ScheduleDeny) bool {
+	for _, e := range entries {
+		if e.Tag != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// NodeDenylist to get the active denylist entries that apply to a node
+func NodeDenylist(entries []ScheduleDeny, node ProfileNode, now time.Time) []ScheduleDeny {
+	var applied []ScheduleDeny
+	for _, e := range entries {
+		if e.Active(now) && e.AppliesTo(node) {
+			applied = append(applied, e)
+		}
+	}
+	return applied
+}
+
+// ApplyScheduleDenylist to remove from a serialized configuration the queries and packs denied for a node.
+// Local packs, defined only by a path, can only be removed as a whole. Anything else is kept as it is.
+func ApplyScheduleDenylist(configuration []byte, entries []ScheduleDeny, node ProfileNode, now time.Time) ([]byte, error) {
+	applied := NodeDenylist(entries, node, now)
+	if len(applied) == 0 {
+		return configuration, nil
+	}
+	var conf map[string]json.RawMessage
+	if err := json.Unmarshal(configuration, &conf); err != nil {
+		return configuration, fmt.Errorf("Unmarshal %v", err)
+	}
+	var schedule map[string]interface{}
+	if raw, ok := conf["schedule"]; ok {
+		if err := json.Unmarshal(raw, &schedule); err != nil {
+			return configuration, fmt.Errorf("Unmarshal schedule %v", err)
+		}
+	}
+	var packs map[string]interface{}
+	if raw, ok := conf["packs"]; ok {
+		if err := json.Unmarshal(raw, &packs); err != nil {
+			return configuration, fmt.Errorf("Unmarshal packs %v", err)
+		}
+	}
+	for _, e := range applied {
+		if e.Pack == "" {
+			delete(schedule, e.Query)
+		}
+		for p, value := range packs {
+			if e.Pack != "" && e.Pack != p {
+				continue
+			}
+			if e.Query == "" {
+				delete(packs, p)
+				continue
+			}
+			entry, ok := value.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if queries, ok := entry["queries"].(map[string]interface{}); ok {
+				delete(queries, e.Query)
+			}
+		}
+	}
+	var err error
+	if schedule != nil {
+		if conf["schedule"], err = json.Marshal(schedule); err != nil {
+			return configuration, fmt.Errorf("Marshal schedule %v", err)
+		}
+	}
+	if packs != nil {
+		if conf["packs"], err = json.Marshal(packs); err != nil {
+			return configuration, fmt.Errorf("Marshal packs %v", err)
+		}
+	}
+	res, err := json.MarshalIndent(conf, "", "  ")
+	if err != nil {
+		return configuration, fmt.Errorf("Marshal %v", err)
+	}
+	return res, nil
+}
+
+// DenylistCandidates to get all the scheduled queries, packs and pack queries of a serialized configuration,
+// sorted by pack and query, so they can be picked for the denylist
+func DenylistCandidates(configuration []byte) ([]DenyCandidate, error) {
+	var conf struct {
+		Schedule map[string]interface{} `json:"schedule"`
+		Packs    map[string]interface{} `json:"packs"`
+	}
+	if err := json.Unmarshal(configuration, &conf); err != nil {
+		return nil, fmt.Errorf("Unmarshal %v", err)
+	}
+	var candidates []DenyCandidate
+	for q := range conf.Schedule {
+		candidates = append(candidates, DenyCandidate{Query: q})
+	}
+	for p, value := range conf.Packs {
+		candidates = append(candidates, DenyCandidate{Pack: p})
+		entry, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if queries, ok := entry["queries"].(map[string]interface{}); ok {
+			for q := range queries {
+				candidates = append(candidates, DenyCandidate{Pack: p, Query: q})
+			}
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].Pack != candidates[j].Pack {
+			return candidates[i].Pack < candidates[j].Pack
+		}
+		return candidates[i].Query < candidates[j].Query
+	})
+	return candidates, nil
+}
+
+// ScheduleDenylist to get the active denylist entries of an environment
+func (environment *Environment) ScheduleDenylist(envid uint) ([]ScheduleDeny, error) {
+	return environment.ScheduleDenylistCtx(context.Background(), envid)
+}
+
+// ScheduleDenylistCtx to get the active denylist entries of an environment, aborting if ctx is cancelled
+func (environment *Environment) ScheduleDenylistCtx(ctx context.Context, envid uint) ([]ScheduleDeny, error) {
+	var entries []ScheduleDeny
+	if err := environment.read().WithContext(ctx).Where("environment_id = ?", envid).Order("created_at").Find(&entries).Error; err != nil {
+		return entries, err
+	}
+	now := time.Now()
+	active := make([]ScheduleDeny, 0, len(entries))
+	for _, e := range entries {
+		if e.Active(now) {
+			active = append(active, e)
+		}
+	}
+	return active, nil
+}
+
+// AddScheduleDeny to add a new entry to the denylist of an environment
+func (environment *Environment) AddScheduleDeny(envid uint, entry ScheduleDeny) (ScheduleDeny, error) {
+	entry.EnvironmentID = envid
+	entry.Pack = strings.TrimSpace(entry.Pack)
+	entry.Query = strings.TrimSpace(entry.Query)
+	if err := ValidateScheduleDeny(entry, time.Now()); err != nil {
+		return entry, err
+	}
+	if err := environment.DB.Create(&entry).Error; err != nil {
+		return entry, fmt.Errorf("Create %v", err)
+	}
+	return entry, nil
+}
+
+// GetScheduleDeny to get one denylist entry of an environment by id
+func (environment *Environment) GetScheduleDeny(envid, id uint) (ScheduleDeny, error) {
+	var entry ScheduleDeny
+	if err := environment.DB.Where("environment_id = ?", envid).First(&entry, id).Error; err != nil {
+		return entry, err
+	}
+	return entry, nil
+}
+
+// DeleteScheduleDeny to lift a denylist entry before it expires
+func (environment *Environment) DeleteScheduleDeny(envid, id uint) error {
+	res := environment.DB.Unscoped().Where("environment_id = ?", envid).Delete(&ScheduleDeny{}, id)
+	if res.Error != nil {
+		return fmt.Errorf("Delete %v", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// LiftExpiredScheduleDenylist to remove the denylist entries that expired, returning them
+func (environment *Environment) LiftExpiredScheduleDenylist() ([]ScheduleDeny, error) {
+	var expired []ScheduleDeny
+	now := time.Now()
+	if err := environment.DB.Where("expires_at > ? AND expires_at <= ?", time.Time{}, now).Find(&expired).Error; err != nil {
+		return expired, err
+	}
+	for _, e := range expired {
+		if err := environment.DB.Unscoped().Delete(&ScheduleDeny{}, e.ID).Error; err != nil {
+			return expired, fmt.Errorf("Delete %v", err)
+		}
+	}
+	return expired, nil
+}
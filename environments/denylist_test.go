@@ -0,0 +1,159 @@
+package environments
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testDenylistConf = `{
+  "options": {"config_refresh": 60},
+  "schedule": {
+    "uptime": {"query": "SELECT * FROM uptime;", "interval": 300},
+    "processes": {"query": "SELECT * FROM processes;", "interval": 600}
+  },
+  "packs": {
+    "hardening": {
+      "platform": "linux",
+      "queries": {
+        "processes": {"query": "SELECT pid FROM processes;", "interval": 3600},
+        "suid_bin": {"query": "SELECT * FROM suid_bin;", "interval": 3600}
+      }
+    },
+    "incident": {
+      "discovery": ["SELECT 1 FROM system_info;"],
+      "queries": {
+        "processes": {"query": "SELECT name FROM processes;", "interval": 60},
+        "sockets": {"query": "SELECT * FROM process_open_sockets;", "interval": 60}
+      }
+    },
+    "local": "/etc/osquery/packs/local.conf"
+  }
+}`
+
+type testDenyConf struct {
+	Options  map[string]interface{}                       `json:"options"`
+	Schedule map[string]interface{}                       `json:"schedule"`
+	Packs    map[string]json.RawMessage                   `json:"packs"`
+	Pack     map[string]map[string]map[string]interface{} `json:"-"`
+}
+
+func parseDenyConf(t *testing.T, conf []byte) testDenyConf {
+	var parsed testDenyConf
+	assert.NoError(t, json.Unmarshal(conf, &parsed))
+	parsed.Pack = make(map[string]map[string]map[string]interface{})
+	for p, raw := range parsed.Packs {
+		var entry struct {
+			Queries map[string]map[string]interface{} `json:"queries"`
+		}
+		if json.Unmarshal(raw, &entry) == nil {
+			parsed.Pack[p] = entry.Queries
+		}
+	}
+	return parsed
+}
+
+func TestValidateScheduleDeny(t *testing.T) {
+	now := time.Date(2022, 3, 8, 14, 0, 0, 0, time.UTC)
+	assert.NoError(t, ValidateScheduleDeny(ScheduleDeny{Query: "processes", Reason: "too expensive"}, now))
+	assert.NoError(t, ValidateScheduleDeny(ScheduleDeny{Pack: "incident", Reason: "closed", ExpiresAt: now.Add(time.Hour)}, now))
+	assert.Error(t, ValidateScheduleDeny(ScheduleDeny{Reason: "nothing"}, now))
+	assert.Error(t, ValidateScheduleDeny(ScheduleDeny{Query: "processes", Reason: " "}, now))
+	assert.Error(t, ValidateScheduleDeny(ScheduleDeny{Query: "processes", Reason: "expired", ExpiresAt: now}, now))
+}
+
+func TestApplyScheduleDenylistNestedPacks(t *testing.T) {
+	now := time.Date(2022, 3, 8, 14, 0, 0, 0, time.UTC)
+	// A query name is removed from the schedule and from every pack defining it
+	entries := []ScheduleDeny{{Query: "processes", Reason: "too expensive"}}
+	conf, err := ApplyScheduleDenylist([]byte(testDenylistConf), entries, ProfileNode{Platform: "ubuntu"}, now)
+	assert.NoError(t, err)
+	parsed := parseDenyConf(t, conf)
+	assert.Equal(t, float64(60), parsed.Options["config_refresh"])
+	assert.Len(t, parsed.Schedule, 1)
+	assert.Contains(t, parsed.Schedule, "uptime")
+	assert.Len(t, parsed.Pack["hardening"], 1)
+	assert.Contains(t, parsed.Pack["hardening"], "suid_bin")
+	assert.Len(t, parsed.Pack["incident"], 1)
+	assert.Contains(t, parsed.Pack["incident"], "sockets")
+	assert.Contains(t, string(parsed.Packs["incident"]), "discovery")
+	assert.Equal(t, `"/etc/osquery/packs/local.conf"`, string(parsed.Packs["local"]))
+	// With a pack only the query in that pack is removed
+	entries = []ScheduleDeny{{Pack: "incident", Query: "processes", Reason: "noisy"}}
+	conf, err = ApplyScheduleDenylist([]byte(testDenylistConf), entries, ProfileNode{Platform: "ubuntu"}, now)
+	assert.NoError(t, err)
+	parsed = parseDenyConf(t, conf)
+	assert.Len(t, parsed.Schedule, 2)
+	assert.Len(t, parsed.Pack["hardening"], 2)
+	assert.Len(t, parsed.Pack["incident"], 1)
+	// Without query the whole pack is removed, local packs too
+	entries = []ScheduleDeny{{Pack: "incident", Reason: "closed"}, {Pack: "local", Reason: "unused"}}
+	conf, err = ApplyScheduleDenylist([]byte(testDenylistConf), entries, ProfileNode{Platform: "ubuntu"}, now)
+	assert.NoError(t, err)
+	parsed = parseDenyConf(t, conf)
+	assert.Len(t, parsed.Packs, 1)
+	assert.Contains(t, parsed.Packs, "hardening")
+	// Expired entries are lifted
+	entries = []ScheduleDeny{{Query: "processes", Reason: "too expensive", ExpiresAt: now.Add(-time.Minute)}}
+	conf, err = ApplyScheduleDenylist([]byte(testDenylistConf), entries, ProfileNode{Platform: "ubuntu"}, now)
+	assert.NoError(t, err)
+	assert.Equal(t, testDenylistConf, string(conf))
+}
+
+func TestApplyScheduleDenylistTagScoped(t *testing.T) {
+	now := time.Date(2022, 3, 8, 14, 0, 0, 0, time.UTC)
+	entries := []ScheduleDeny{
+		{Pack: "hardening", Tag: "build", Reason: "build servers run their own hardening"},
+		{Pack: "incident", Query: "sockets", Platform: "darwin", Reason: "slow on macOS"},
+	}
+	assert.True(t, DenylistUsesTags(entries))
+	// Nodes without the tag keep the pack
+	conf, err := ApplyScheduleDenylist([]byte(testDenylistConf), entries, ProfileNode{Platform: "ubuntu", Tags: []string{"laptops"}}, now)
+	assert.NoError(t, err)
+	parsed := parseDenyConf(t, conf)
+	assert.Len(t, parsed.Pack["hardening"], 2)
+	assert.Len(t, parsed.Pack["incident"], 2)
+	// Nodes with the tag lose the pack, the rest of the packs stay
+	conf, err = ApplyScheduleDenylist([]byte(testDenylistConf), entries, ProfileNode{Platform: "ubuntu", Tags: []string{"laptops", "build"}}, now)
+	assert.NoError(t, err)
+	parsed = parseDenyConf(t, conf)
+	assert.NotContains(t, parsed.Packs, "hardening")
+	assert.Len(t, parsed.Pack["incident"], 2)
+	// Platform scoped entries only apply to the platform
+	conf, err = ApplyScheduleDenylist([]byte(testDenylistConf), entries, ProfileNode{Platform: "darwin", Tags: []string{"build"}}, now)
+	assert.NoError(t, err)
+	parsed = parseDenyConf(t, conf)
+	assert.NotContains(t, parsed.Packs, "hardening")
+	assert.Len(t, parsed.Pack["incident"], 1)
+	assert.Contains(t, parsed.Pack["incident"], "processes")
+}
+
+func TestDenylistCandidates(t *testing.T) {
+	candidates, err := DenylistCandidates([]byte(testDenylistConf))
+	assert.NoError(t, err)
+	assert.Equal(t, []DenyCandidate{
+		{Query: "processes"},
+		{Query: "uptime"},
+		{Pack: "hardening"},
+		{Pack: "hardening", Query: "processes"},
+		{Pack: "hardening", Query: "suid_bin"},
+		{Pack: "incident"},
+		{Pack: "incident", Query: "processes"},
+		{Pack: "incident", Query: "sockets"},
+		{Pack: "local"},
+	}, candidates)
+}
+
+func TestNodeConfigurationDenylist(t *testing.T) {
+	env := TLSEnvironment{Configuration: testDenylistConf}
+	denylist := []ScheduleDeny{{Query: "processes", Reason: "too expensive"}}
+	overrides := []ScheduleOverride{{UUID: "AAA", Query: "uptime", Interval: 30}}
+	conf, _, err := NodeConfiguration(env, nil, nil, denylist, overrides, ProfileNode{Platform: "ubuntu"})
+	assert.NoError(t, err)
+	parsed := parseDenyConf(t, conf)
+	assert.Len(t, parsed.Schedule, 1)
+	assert.Equal(t, float64(30), parsed.Schedule["uptime"].(map[string]interface{})["interval"])
+	assert.NotContains(t, parsed.Pack["incident"], "processes")
+}
@@ -0,0 +1,150 @@
+package environments
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// EnrollMetadataPrefix for the variables used by enroll scripts to pass metadata, like OSCTRL_META_OWNER
+	EnrollMetadataPrefix string = "OSCTRL_META_"
+	// MaxEnrollFieldLength is the maximum length of names and values of enroll metadata
+	MaxEnrollFieldLength int = 256
+)
+
+// Valid names for enroll fields, so they can be passed as environment variables
+var enrollFieldNameRegexp = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+// EnrollField to define a custom metadata field collected when nodes enroll in an environment
+type EnrollField struct {
+	gorm.Model
+	EnvironmentID uint `gorm:"index"`
+	Name          string
+	Description   string
+	Regex         string
+	Required      bool
+}
+
+// ValidateEnrollField to check the name and the regex of an enroll field
+func ValidateEnrollField(field EnrollField) error {
+	if !enrollFieldNameRegexp.MatchString(field.Name) {
+		return fmt.Errorf("invalid name %s, use lowercase letters, digits and underscores", field.Name)
+	}
+	if field.Regex != "" {
+		if _, err := regexp.Compile(field.Regex); err != nil {
+			return fmt.Errorf("invalid regex %s - %v", field.Regex, err)
+		}
+	}
+	return nil
+}
+
+// ParseEnrollSecret to split the content of a secret file in the secret and the enroll metadata.
+// The secret is the first line and every other line is a field=value pair.
+func ParseEnrollSecret(raw string) (string, map[string]string) {
+	lines := strings.Split(strings.TrimSpace(raw), "\n")
+	secret := strings.TrimSpace(lines[0])
+	metadata := make(map[string]string)
+	for _, l := range lines[1:] {
+		pair := strings.SplitN(strings.TrimSpace(l), "=", 2)
+		if len(pair) != 2 {
+			continue
+		}
+		metadata[strings.ToLower(strings.TrimSpace(pair[0]))] = strings.TrimSpace(pair[1])
+	}
+	return secret, metadata
+}
+
+// ValidateEnrollMetadata to check the metadata sent by a node against the fields of the environment.
+// Only values of defined fields are returned, required fields must be present and values must match the regex.
+func ValidateEnrollMetadata(fields []EnrollField, metadata map[string]string) (map[string]string, error) {
+	values := make(map[string]string)
+	var problems []string
+	for _, f := range fields {
+		value, ok := metadata[f.Name]
+		if !ok || value == "" {
+			if f.Required {
+				problems = append(problems, fmt.Sprintf("%s is required", f.Name))
+			}
+			continue
+		}
+		if len(value) > MaxEnrollFieldLength {
+			problems = append(problems, fmt.Sprintf("%s is too long", f.Name))
+			continue
+		}
+		if f.Regex != "" {
+			re, err := regexp.Compile("^(?:" + f.Regex + ")$")
+			if err != nil || !re.MatchString(value) {
+				problems = append(problems, fmt.Sprintf("%s does not match %s", f.Name, f.Regex))
+				continue
+			}
+		}
+		values[f.Name] = value
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return values, fmt.Errorf("invalid enroll metadata: %s", strings.Join(problems, ", "))
+	}
+	return values, nil
+}
+
+// EnrollFields to get the enroll fields of an environment, sorted by name
+func (environment *Environment) EnrollFields(envid uint) ([]EnrollField, error) {
+	return environment.EnrollFieldsCtx(context.Background(), envid)
+}
+
+// EnrollFieldsCtx to get the enroll fields of an environment, sorted by name
+func (environment *Environment) EnrollFieldsCtx(ctx context.Context, envid uint) ([]EnrollField, error) {
+	var fields []EnrollField
+	if err := environment.read().WithContext(ctx).Where("environment_id = ?", envid).Order("name").Find(&fields).Error; err != nil {
+		return fields, err
+	}
+	return fields, nil
+}
+
+// SetEnrollField to create or update an enroll field of an environment, by name.
+// Values already stored in nodes are not modified.
+func (environment *Environment) SetEnrollField(envid uint, field EnrollField) (EnrollField, error) {
+	field.Name = strings.ToLower(strings.TrimSpace(field.Name))
+	if err := ValidateEnrollField(field); err != nil {
+		return field, err
+	}
+	var existing EnrollField
+	err := environment.DB.Where("environment_id = ? AND name = ?", envid, field.Name).First(&existing).Error
+	if err == gorm.ErrRecordNotFound {
+		field.EnvironmentID = envid
+		if err := environment.DB.Create(&field).Error; err != nil {
+			return field, fmt.Errorf("Create %v", err)
+		}
+		return field, nil
+	}
+	if err != nil {
+		return field, fmt.Errorf("First %v", err)
+	}
+	err = environment.DB.Model(&existing).Updates(map[string]interface{}{
+		"description": field.Description,
+		"regex":       field.Regex,
+		"required":    field.Required,
+	}).Error
+	if err != nil {
+		return existing, fmt.Errorf("Updates %v", err)
+	}
+	existing.Description, existing.Regex, existing.Required = field.Description, field.Regex, field.Required
+	return existing, nil
+}
+
+// DeleteEnrollField to delete an enroll field of an environment, by name
+func (environment *Environment) DeleteEnrollField(envid uint, name string) error {
+	res := environment.DB.Unscoped().Where("environment_id = ? AND name = ?", envid, strings.ToLower(name)).Delete(&EnrollField{})
+	if res.Error != nil {
+		return fmt.Errorf("Delete %v", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package environments
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseEnrollSecret(t *testing.T) {
+	secret, metadata := ParseEnrollSecret("  secret  \n")
+	assert.Equal(t, "secret", secret)
+	assert.Empty(t, metadata)
+	secret, metadata = ParseEnrollSecret("secret\r\nOWNER=alice@example.com\r\ncost_center = 12=34\r\ninvalid\r\n")
+	assert.Equal(t, "secret", secret)
+	assert.Equal(t, map[string]string{"owner": "alice@example.com", "cost_center": "12=34"}, metadata)
+}
+
+func TestValidateEnrollField(t *testing.T) {
+	assert.NoError(t, ValidateEnrollField(EnrollField{Name: "asset_tag", Regex: "[A-Z]{2}[0-9]+"}))
+	assert.Error(t, ValidateEnrollField(EnrollField{Name: "Asset-Tag"}))
+	assert.Error(t, ValidateEnrollField(EnrollField{Name: "1tag"}))
+	assert.Error(t, ValidateEnrollField(EnrollField{Name: "tag", Regex: "[A-Z"}))
+}
+
+func TestValidateEnrollMetadata(t *testing.T) {
+	fields := []EnrollField{
+		{Name: "owner", Required: true},
+		{Name: "asset_tag", Regex: "AT[0-9]+"},
+	}
+	values, err := ValidateEnrollMetadata(fields, map[string]string{"owner": "alice", "asset_tag": "AT123", "other": "x"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"owner": "alice", "asset_tag": "AT123"}, values)
+	// Optional fields can be missing
+	values, err = ValidateEnrollMetadata(fields, map[string]string{"owner": "alice"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"owner": "alice"}, values)
+	// The regex must match the whole value
+	_, err = ValidateEnrollMetadata(fields, map[string]string{"asset_tag": "XAT123"})
+	assert.EqualError(t, err, "invalid enroll metadata: asset_tag does not match AT[0-9]+, owner is required")
+}
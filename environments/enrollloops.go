@@ -0,0 +1,25 @@
+package environments
+
+import (
+	"fmt"
+)
+
+// UpdateEnrollLoops to update the detection of enroll loops of an environment: enrolls per hour to flag a host,
+// minutes without enrolls to clear the flag, 0 for the defaults, and if looping hosts get a backoff response
+func (environment *Environment) UpdateEnrollLoops(idEnv string, limit, quiet int, backoff bool) error {
+	if limit < 0 {
+		return fmt.Errorf("invalid enroll loop limit %d", limit)
+	}
+	if quiet < 0 {
+		return fmt.Errorf("invalid enroll loop quiet period %d", quiet)
+	}
+	values := map[string]interface{}{
+		"enroll_loop_limit": limit,
+		"enroll_loop_quiet": quiet,
+		"enroll_backoff":    backoff,
+	}
+	if err := environment.DB.Model(&TLSEnvironment{}).Where("name = ? OR uuid = ?", idEnv, idEnv).Updates(values).Error; err != nil {
+		return fmt.Errorf("UpdateEnrollLoops %v", err)
+	}
+	return nil
+}
@@ -1,10 +1,14 @@
 package environments
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
+	"github.com/jmpsec/osctrl/backend"
+	"github.com/jmpsec/osctrl/locales"
 	"github.com/jmpsec/osctrl/settings"
 	"github.com/jmpsec/osctrl/utils"
 	"gorm.io/gorm"
@@ -86,15 +90,66 @@ type TLSEnvironment struct {
 	CarverInitPath   string
 	CarverBlockPath  string
 	AcceptEnrolls    bool
+	CarveScanPolicy  string
+	IdentityPolicy   string
+	StormThreshold   int
+	Locale           string
+	AllowedPlatforms string
+	OsqueryPin       string
+	OsqueryPins      string
+	QueryExpiration  int
+	Category         string `gorm:"index"`
+	EnrollLoopLimit  int
+	EnrollLoopQuiet  int
+	EnrollBackoff    bool
+	NamingLowercase  bool
+	NamingDomains    string
+	NamingPreferFQDN bool
+	PseudonymColumns string
+	PseudonymTables  string
+	PseudonymKey     string `json:"-"`
+	ConfigVersion    int
+	TemplateID       uint
+	TemplateVersion  int
 	UserID           uint
 }
 
 // MapEnvironments to hold the TLS environments by name and UUID
 type MapEnvironments map[string]TLSEnvironment
 
+// EnvSecrets to hold the secrets of a TLS environment, they are kept out of the environments map shared in the cache
+type EnvSecrets struct {
+	ID               uint
+	Secret           string
+	EnrollSecretPath string
+	RemoveSecretPath string
+	Certificate      string
+	PseudonymKey     string
+}
+
+// WithoutSecrets to get a copy of the environments map without the secrets of the environments
+func (m MapEnvironments) WithoutSecrets() MapEnvironments {
+	stripped := make(MapEnvironments, len(m))
+	for key, env := range m {
+		env.Secret = ""
+		env.EnrollSecretPath = ""
+		env.RemoveSecretPath = ""
+		env.Certificate = ""
+		env.PseudonymKey = ""
+		stripped[key] = env
+	}
+	return stripped
+}
+
 // Environment keeps all TLS Environments
 type Environment struct {
-	DB *gorm.DB
+	DB     *gorm.DB
+	Reader func() *gorm.DB
+	access *AccessRecorder
+	// Only one recorder of data accesses, even with several services sharing the environments
+	accessOnce sync.Once
+	certs      map[uint]cachedCertificate
+	certsMux   sync.Mutex
 }
 
 // CreateEnvironment to initialize the environment struct and tables
@@ -105,13 +160,124 @@ func CreateEnvironment(backend *gorm.DB) *Environment {
 	if err := backend.AutoMigrate(&TLSEnvironment{}); err != nil {
 		log.Fatalf("Failed to AutoMigrate table (tls_environments): %v", err)
 	}
+	// table env_certificates
+	if err := migrateCertificates(backend); err != nil {
+		log.Fatalf("Failed to migrate table (env_certificates): %v", err)
+	}
+	if err := backend.AutoMigrate(&EnvCertificate{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (env_certificates): %v", err)
+	}
+	// table node_certificates
+	if err := backend.AutoMigrate(&NodeCertificate{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (node_certificates): %v", err)
+	}
+	// table schedule_overrides
+	if err := backend.AutoMigrate(&ScheduleOverride{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (schedule_overrides): %v", err)
+	}
+	// table schedule_denies
+	if err := backend.AutoMigrate(&ScheduleDeny{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (schedule_denies): %v", err)
+	}
+	// table config_inputs
+	if err := backend.AutoMigrate(&ConfigInput{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (config_inputs): %v", err)
+	}
+	// table node_config_lineages
+	if err := backend.AutoMigrate(&NodeConfigLineage{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (node_config_lineages): %v", err)
+	}
+	// table enroll_fields
+	if err := backend.AutoMigrate(&EnrollField{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (enroll_fields): %v", err)
+	}
+	// table performance_profiles
+	if err := backend.AutoMigrate(&PerformanceProfile{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (performance_profiles): %v", err)
+	}
+	// table windows_event_channels
+	if err := backend.AutoMigrate(&WindowsEventChannel{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (windows_event_channels): %v", err)
+	}
+	// table env_activities
+	if err := backend.AutoMigrate(&EnvActivity{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (env_activities): %v", err)
+	}
+	// table storm_states
+	if err := backend.AutoMigrate(&StormState{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (storm_states): %v", err)
+	}
+	// table storage_samples
+	if err := backend.AutoMigrate(&StorageSample{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (storage_samples): %v", err)
+	}
+	// table storage_counters
+	if err := backend.AutoMigrate(&StorageCounter{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (storage_counters): %v", err)
+	}
+	// table storage_quota
+	if err := backend.AutoMigrate(&StorageQuota{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (storage_quota): %v", err)
+	}
+	// table config_versions
+	if err := backend.AutoMigrate(&ConfigVersion{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (config_versions): %v", err)
+	}
+	// table scheduled_changes
+	if err := backend.AutoMigrate(&ScheduledChange{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (scheduled_changes): %v", err)
+	}
+	// table pack_sources
+	if err := backend.AutoMigrate(&PackSource{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (pack_sources): %v", err)
+	}
+	// table pack_sync_packs
+	if err := backend.AutoMigrate(&PackSyncPack{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (pack_sync_packs): %v", err)
+	}
+	// table env_templates
+	if err := backend.AutoMigrate(&EnvTemplate{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (env_templates): %v", err)
+	}
+	// table env_template_versions
+	if err := backend.AutoMigrate(&EnvTemplateVersion{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (env_template_versions): %v", err)
+	}
+	// table category_policies
+	if err := backend.AutoMigrate(&CategoryPolicy{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (category_policies): %v", err)
+	}
+	// table pseudonym_mappings
+	if err := backend.AutoMigrate(&PseudonymMapping{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (pseudonym_mappings): %v", err)
+	}
+	// table data_accesses
+	if err := backend.AutoMigrate(&DataAccess{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (data_accesses): %v", err)
+	}
 	return e
 }
 
+// Helper to get the connection for reads that tolerate replication lag, DB if there is no Reader
+func (environment *Environment) read() *gorm.DB {
+	if environment.Reader != nil {
+		return environment.Reader()
+	}
+	return environment.DB
+}
+
 // Get TLS Environment by name or UUID
 func (environment *Environment) Get(identifier string) (TLSEnvironment, error) {
+	return environment.GetCtx(context.Background(), identifier)
+}
+
+// GetCtx to retrieve TLS environment by name or UUID, aborting if ctx is cancelled
+func (environment *Environment) GetCtx(ctx context.Context, identifier string) (TLSEnvironment, error) {
 	var env TLSEnvironment
-	if err := environment.DB.Where("name = ? OR uuid = ?", identifier, identifier).First(&env).Error; err != nil {
+	err := backend.Retry(ctx, "environments.Get", func() error {
+		return environment.read().WithContext(ctx).Where("name = ? OR uuid = ?", identifier, identifier).First(&env).Error
+	})
+	if err != nil {
 		return env, err
 	}
 	return env, nil
@@ -174,8 +340,13 @@ func (environment *Environment) Exists(identifier string) bool {
 
 // All gets all TLS Environment
 func (environment *Environment) All() ([]TLSEnvironment, error) {
+	return environment.AllCtx(context.Background())
+}
+
+// AllCtx gets all TLS Environment, aborting if ctx is cancelled
+func (environment *Environment) AllCtx(ctx context.Context) ([]TLSEnvironment, error) {
 	var envs []TLSEnvironment
-	if err := environment.DB.Find(&envs).Error; err != nil {
+	if err := environment.read().WithContext(ctx).Find(&envs).Error; err != nil {
 		return envs, err
 	}
 	return envs, nil
@@ -209,7 +380,12 @@ func (environment *Environment) UUIDs() ([]string, error) {
 
 // GetMap returns the map of environments by name and UUID
 func (environment *Environment) GetMap() (MapEnvironments, error) {
-	all, err := environment.All()
+	return environment.GetMapCtx(context.Background())
+}
+
+// GetMapCtx returns the map of environments by name and UUID, aborting if ctx is cancelled
+func (environment *Environment) GetMapCtx(ctx context.Context) (MapEnvironments, error) {
+	all, err := environment.AllCtx(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("error getting environments %v", err)
 	}
@@ -221,6 +397,31 @@ func (environment *Environment) GetMap() (MapEnvironments, error) {
 	return _map, nil
 }
 
+// LoadSecretsCtx to fill the secrets of the environments of a map from the DB, reading only their columns
+func (environment *Environment) LoadSecretsCtx(ctx context.Context, envsmap MapEnvironments) error {
+	var secrets []EnvSecrets
+	err := backend.Retry(ctx, "environments.LoadSecrets", func() error {
+		return environment.read().WithContext(ctx).Model(&TLSEnvironment{}).Select("id", "secret", "enroll_secret_path", "remove_secret_path", "certificate", "pseudonym_key").Find(&secrets).Error
+	})
+	if err != nil {
+		return fmt.Errorf("error getting secrets of environments %v", err)
+	}
+	byID := make(map[uint]EnvSecrets, len(secrets))
+	for _, s := range secrets {
+		byID[s.ID] = s
+	}
+	for key, env := range envsmap {
+		s := byID[env.ID]
+		env.Secret = s.Secret
+		env.EnrollSecretPath = s.EnrollSecretPath
+		env.RemoveSecretPath = s.RemoveSecretPath
+		env.Certificate = s.Certificate
+		env.PseudonymKey = s.PseudonymKey
+		envsmap[key] = env
+	}
+	return nil
+}
+
 // Delete TLS Environment by name or UUID
 func (environment *Environment) Delete(identifier string) error {
 	env, err := environment.Get(identifier)
@@ -442,6 +643,41 @@ func (environment *Environment) NotExpireRemove(idEnv string) error {
 	return nil
 }
 
+// UpdateCarveScanPolicy to update the policy to download scanned carves for an environment
+func (environment *Environment) UpdateCarveScanPolicy(idEnv, policy string) error {
+	if err := environment.DB.Model(&TLSEnvironment{}).Where("name = ? OR uuid = ?", idEnv, idEnv).Update("carve_scan_policy", policy).Error; err != nil {
+		return fmt.Errorf("UpdateCarveScanPolicy %v", err)
+	}
+	return nil
+}
+
+// UpdateQueryExpiration to update the default expiration in hours of the queries of an environment, 0 for the default
+func (environment *Environment) UpdateQueryExpiration(idEnv string, hours int) error {
+	if err := environment.DB.Model(&TLSEnvironment{}).Where("name = ? OR uuid = ?", idEnv, idEnv).Update("query_expiration", hours).Error; err != nil {
+		return fmt.Errorf("UpdateQueryExpiration %v", err)
+	}
+	return nil
+}
+
+// UpdateIdentityPolicy to update the policy to bind the identity of nodes for an environment
+func (environment *Environment) UpdateIdentityPolicy(idEnv, policy string) error {
+	if err := environment.DB.Model(&TLSEnvironment{}).Where("name = ? OR uuid = ?", idEnv, idEnv).Update("identity_policy", policy).Error; err != nil {
+		return fmt.Errorf("UpdateIdentityPolicy %v", err)
+	}
+	return nil
+}
+
+// UpdateLocale to update the locale of the scripts and reports of an environment, empty uses the default
+func (environment *Environment) UpdateLocale(idEnv, locale string) error {
+	if !locales.IsValid(locale) {
+		return fmt.Errorf("invalid locale %s", locale)
+	}
+	if err := environment.DB.Model(&TLSEnvironment{}).Where("name = ? OR uuid = ?", idEnv, idEnv).Update("locale", locale).Error; err != nil {
+		return fmt.Errorf("UpdateLocale %v", err)
+	}
+	return nil
+}
+
 // DebugHTTP to check if the environment has enabled debugging for HTTP
 func (environment *Environment) DebugHTTP(name string) bool {
 	env, err := environment.Get(name)
@@ -0,0 +1,103 @@
+package environments
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetCtxCancelled(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db, got error: %v", err)
+	}
+	defer mockDB.Close()
+	_postgres, err := gorm.Open(postgres.New(postgres.Config{Conn: mockDB}), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("unable to create new postgres database: %v", err)
+	}
+	manager := &Environment{DB: _postgres}
+	// Slow query, as if the database was under pressure
+	mock.ExpectQuery(`SELECT \* FROM "tls_environments"`).WillDelayFor(5 * time.Second).WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "dev"))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = manager.GetCtx(ctx, "dev")
+	assert.Error(t, err)
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetCtxFailover(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db, got error: %v", err)
+	}
+	defer mockDB.Close()
+	_postgres, err := gorm.Open(postgres.New(postgres.Config{Conn: mockDB}), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("unable to create new postgres database: %v", err)
+	}
+	manager := &Environment{DB: _postgres}
+	// Primary going away, the read is retried once the replica is promoted
+	mock.ExpectQuery(`SELECT \* FROM "tls_environments"`).WillReturnError(&pgconn.PgError{Code: "57P01"})
+	mock.ExpectQuery(`SELECT \* FROM "tls_environments"`).WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "dev"))
+	env, err := manager.GetCtx(context.Background(), "dev")
+	assert.NoError(t, err)
+	assert.Equal(t, "dev", env.Name)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateNotRetried(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db, got error: %v", err)
+	}
+	defer mockDB.Close()
+	_postgres, err := gorm.Open(postgres.New(postgres.Config{Conn: mockDB}), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("unable to create new postgres database: %v", err)
+	}
+	manager := &Environment{DB: _postgres}
+	// Inserts are not idempotent, a transient failure must be returned without retrying
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "tls_environments"`).WillReturnError(&pgconn.PgError{Code: "57P01"})
+	mock.ExpectRollback()
+	err = manager.Create(TLSEnvironment{Name: "dev"})
+	assert.Error(t, err)
+	// A retry would fail with an unexpected call instead of the original error
+	assert.Contains(t, err.Error(), "57P01")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLoadSecretsCtx(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db, got error: %v", err)
+	}
+	defer mockDB.Close()
+	_postgres, err := gorm.Open(postgres.New(postgres.Config{Conn: mockDB}), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("unable to create new postgres database: %v", err)
+	}
+	manager := &Environment{DB: _postgres}
+	env := TLSEnvironment{Name: "dev", UUID: "uuid-dev", Secret: "secret", Certificate: "cert", PseudonymKey: "key"}
+	env.ID = 1
+	envsmap := MapEnvironments{env.Name: env, env.UUID: env}.WithoutSecrets()
+	assert.Empty(t, envsmap["dev"].Secret)
+	assert.Empty(t, envsmap["uuid-dev"].PseudonymKey)
+	// Only the columns of the secrets are read
+	mock.ExpectQuery(`SELECT "id","secret","enroll_secret_path","remove_secret_path","certificate","pseudonym_key" FROM "tls_environments"`).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "secret", "enroll_secret_path", "remove_secret_path", "certificate", "pseudonym_key"}).AddRow(1, "secret", "", "", "cert", "key"))
+	assert.NoError(t, manager.LoadSecretsCtx(context.Background(), envsmap))
+	assert.Equal(t, env, envsmap["dev"])
+	assert.Equal(t, env, envsmap["uuid-dev"])
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,56 @@
+package environments
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// FlagVersions are the osquery versions that introduced flags, nodes running older versions refuse to start with them
+var FlagVersions = map[string]string{
+	"enable_bpf_events":            "4.6.0",
+	"disable_endpointsecurity":     "4.7.0",
+	"disable_endpointsecurity_fim": "5.3.0",
+	"es_fim_mute_path_literal":     "5.5.0",
+	"es_fim_mute_path_prefix":      "5.5.0",
+}
+
+// Helper to get the name of a flag from a line of a flags file, empty for comments and blank lines
+func flagName(line string) string {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ""
+	}
+	line = strings.TrimLeft(line, "-")
+	if i := strings.IndexAny(line, "= "); i >= 0 {
+		line = line[:i]
+	}
+	return line
+}
+
+// LintFlags to get the warnings for flags that need a newer osquery version than the reference version
+func LintFlags(flags, reference string) []string {
+	var warnings []string
+	if reference == "" {
+		return warnings
+	}
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(flags, "\n") {
+		name := flagName(line)
+		requires, ok := FlagVersions[name]
+		if !ok || seen[name] {
+			continue
+		}
+		seen[name] = true
+		if CompareVersions(reference, requires) < 0 {
+			warnings = append(warnings, fmt.Sprintf("--%s requires osquery %s, the reference version is %s", name, requires, reference))
+		}
+	}
+	sort.Strings(warnings)
+	return warnings
+}
+
+// LintFlagsEnv to get the warnings for the flags of an environment, against the oldest version pinned in it
+func LintFlagsEnv(env TLSEnvironment) []string {
+	return LintFlags(env.Flags, ReferenceVersion(env))
+}
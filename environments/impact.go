@@ -0,0 +1,269 @@
+package environments
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// ImpactHeavyExecutions is the number of added executions per day of scheduled queries to flag a change as heavy
+	ImpactHeavyExecutions int64 = 1000000
+	// secondsPerDay to estimate the executions per day of scheduled queries
+	secondsPerDay float64 = 86400
+)
+
+// PlatformImpact to hold the active nodes of one platform receiving a change of the configuration
+type PlatformImpact struct {
+	Platform   string `json:"platform"`
+	Nodes      int64  `json:"nodes"`
+	Executions int64  `json:"added_executions"`
+}
+
+// ConfigImpact to hold the active nodes receiving a change of the configuration at their next fetch,
+// with the estimated executions per day of scheduled queries added by the change
+type ConfigImpact struct {
+	Sections   []string         `json:"sections"`
+	Active     int64            `json:"active_nodes"`
+	Affected   int64            `json:"affected_nodes"`
+	Platforms  []PlatformImpact `json:"platforms"`
+	Executions int64            `json:"added_executions"`
+	Heavy      bool             `json:"heavy"`
+	CountsDay  time.Time        `json:"counts_day"`
+	// Verification of the flags and configuration against the osquery versions of the nodes, only in previews
+	Verification *VerifyReport `json:"verification,omitempty"`
+}
+
+// ProposedConfig to get an environment with one section of the configuration replaced, without saving it.
+// The full configuration replaces all of its parts.
+func (environment *Environment) ProposedConfig(env TLSEnvironment, section, value string) (TLSEnvironment, error) {
+	switch section {
+	case ActivityOptions:
+		env.Options = value
+	case ActivitySchedule:
+		env.Schedule = value
+	case ActivityPacks:
+		env.Packs = value
+	case ActivityDecorators:
+		env.Decorators = value
+	case ActivityATC:
+		env.ATC = value
+	case ActivityFlags:
+		env.Flags = value
+	case ActivityConfiguration:
+		cnf, err := environment.GenStructConf([]byte(value))
+		if err != nil {
+			return env, fmt.Errorf("GenStructConf %v", err)
+		}
+		parts := map[*string]interface{}{
+			&env.Options:    cnf.Options,
+			&env.Schedule:   cnf.Schedule,
+			&env.Packs:      cnf.Packs,
+			&env.Decorators: cnf.Decorators,
+			&env.ATC:        cnf.ATC,
+		}
+		for field, part := range parts {
+			serialized, err := environment.GenSerializedConf(part, true)
+			if err != nil {
+				return env, fmt.Errorf("GenSerializedConf %v", err)
+			}
+			*field = serialized
+		}
+		env.Configuration = value
+	default:
+		return env, fmt.Errorf("unknown section %s", section)
+	}
+	return env, nil
+}
+
+// ProposedServed to get an environment with one section of the configuration replaced and the full configuration
+// generated again from its parts, as it would be served, without saving it
+func (environment *Environment) ProposedServed(env TLSEnvironment, section, value string) (TLSEnvironment, error) {
+	proposed, err := environment.ProposedConfig(env, section, value)
+	if err != nil {
+		return proposed, err
+	}
+	if section != ActivityConfiguration && section != ActivityFlags {
+		if proposed.Configuration, err = environment.GenConfiguration(proposed); err != nil {
+			return proposed, err
+		}
+	}
+	return proposed, nil
+}
+
+// Helper to compare two sections of the configuration, ignoring the format of JSON values
+func sameSection(a, b string) bool {
+	var va, vb interface{}
+	if json.Unmarshal([]byte(a), &va) != nil || json.Unmarshal([]byte(b), &vb) != nil {
+		return strings.TrimSpace(a) == strings.TrimSpace(b)
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+// Helper to parse the schedule and packs of an environment, empty sections have no queries
+func (environment *Environment) scheduleAndPacks(env TLSEnvironment) (ScheduleConf, PacksEntries, error) {
+	schedule := ScheduleConf{}
+	if strings.TrimSpace(env.Schedule) != "" {
+		parsed, err := environment.GenStructSchedule([]byte(env.Schedule))
+		if err != nil {
+			return schedule, nil, fmt.Errorf("schedule %v", err)
+		}
+		if parsed != nil {
+			schedule = parsed
+		}
+	}
+	packs := PacksEntries{}
+	if strings.TrimSpace(env.Packs) != "" {
+		parsed, err := environment.GenPacksEntries([]byte(env.Packs))
+		if err != nil {
+			return schedule, packs, fmt.Errorf("packs %v", err)
+		}
+		packs = parsed
+	}
+	return schedule, packs, nil
+}
+
+// Helper to estimate the executions per day of a query by its interval, reduced by its shard percentage
+func dailyExecutions(interval, shard json.Number) float64 {
+	i, err := interval.Int64()
+	if err != nil || i <= 0 {
+		return 0
+	}
+	daily := secondsPerDay / float64(i)
+	if s, err := shard.Int64(); err == nil && s > 0 && s < 100 {
+		daily = daily * float64(s) / 100
+	}
+	return daily
+}
+
+// Helper to estimate the executions per day of the scheduled and pack queries of one node of a platform
+func nodeExecutions(schedule ScheduleConf, packs PacksEntries, platform string) float64 {
+	var total float64
+	for _, q := range schedule {
+		if IsPlatformQuery(strings.ToLower(q.Platform), platform) {
+			total += dailyExecutions(q.Interval, q.Shard)
+		}
+	}
+	for _, p := range packs {
+		if !IsPlatformQuery(strings.ToLower(p.Platform), platform) {
+			continue
+		}
+		for _, q := range p.Queries {
+			if !IsPlatformQuery(strings.ToLower(q.Platform), platform) {
+				continue
+			}
+			shard := q.Shard
+			if shard == "" {
+				shard = p.Shard
+			}
+			total += dailyExecutions(q.Interval, shard)
+		}
+	}
+	return total
+}
+
+// ConfigImpact to calculate which active nodes receive the changes between two configurations of an environment.
+// Active nodes are given by platform, changes to scheduled queries and packs only reach their platforms.
+func (environment *Environment) ConfigImpact(before, after TLSEnvironment, active map[string]int64) (ConfigImpact, error) {
+	impact := ConfigImpact{Platforms: []PlatformImpact{}}
+	for _, nodes := range active {
+		impact.Active += nodes
+	}
+	beforeSchedule, beforePacks, err := environment.scheduleAndPacks(before)
+	if err != nil {
+		// The current configuration can not be parsed, so everything it had is considered changed
+		beforeSchedule, beforePacks = ScheduleConf{}, PacksEntries{}
+	}
+	afterSchedule, afterPacks, err := environment.scheduleAndPacks(after)
+	if err != nil {
+		return impact, err
+	}
+	// Platforms of the scheduled queries and packs that changed, sections for all nodes match any platform
+	var changed []string
+	all := false
+	vBefore, vAfter := versionOf(before), versionOf(after)
+	for _, s := range ConfigSections {
+		if s == ActivityConfiguration || sameSection(vBefore.Section(s), vAfter.Section(s)) {
+			continue
+		}
+		impact.Sections = append(impact.Sections, s)
+		switch s {
+		case ActivitySchedule:
+			for name, q := range afterSchedule {
+				old, ok := beforeSchedule[name]
+				if ok && reflect.DeepEqual(q, old) {
+					continue
+				}
+				changed = append(changed, q.Platform)
+				if ok {
+					changed = append(changed, old.Platform)
+				}
+			}
+			for name, q := range beforeSchedule {
+				if _, ok := afterSchedule[name]; !ok {
+					changed = append(changed, q.Platform)
+				}
+			}
+		case ActivityPacks:
+			for name, p := range afterPacks {
+				old, ok := beforePacks[name]
+				if ok && reflect.DeepEqual(p, old) {
+					continue
+				}
+				changed = append(changed, p.Platform)
+				if ok {
+					changed = append(changed, old.Platform)
+				}
+			}
+			for name, p := range beforePacks {
+				if _, ok := afterPacks[name]; !ok {
+					changed = append(changed, p.Platform)
+				}
+			}
+		default:
+			all = true
+		}
+	}
+	for platform, nodes := range active {
+		p := strings.ToLower(platform)
+		affected := all
+		for _, c := range changed {
+			if IsPlatformQuery(strings.ToLower(c), p) {
+				affected = true
+				break
+			}
+		}
+		delta := nodeExecutions(afterSchedule, afterPacks, p) - nodeExecutions(beforeSchedule, beforePacks, p)
+		executions := int64(math.Round(delta * float64(nodes)))
+		if !affected && executions == 0 {
+			continue
+		}
+		impact.Affected += nodes
+		impact.Executions += executions
+		impact.Platforms = append(impact.Platforms, PlatformImpact{Platform: platform, Nodes: nodes, Executions: executions})
+	}
+	sort.Slice(impact.Platforms, func(i, j int) bool {
+		if impact.Platforms[i].Nodes != impact.Platforms[j].Nodes {
+			return impact.Platforms[i].Nodes > impact.Platforms[j].Nodes
+		}
+		return impact.Platforms[i].Platform < impact.Platforms[j].Platform
+	})
+	impact.Heavy = impact.Executions >= ImpactHeavyExecutions
+	return impact, nil
+}
+
+// ParseImpact to get the impact recorded with a version, nil for versions without it
+func (v ConfigVersion) ParseImpact() *ConfigImpact {
+	if v.Impact == "" {
+		return nil
+	}
+	var impact ConfigImpact
+	if err := json.Unmarshal([]byte(v.Impact), &impact); err != nil {
+		return nil
+	}
+	return &impact
+}
@@ -0,0 +1,76 @@
+package environments
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProposedConfig(t *testing.T) {
+	environment := &Environment{}
+	env := TLSEnvironment{Options: `{"a": 1}`}
+	proposed, err := environment.ProposedConfig(env, ActivitySchedule, `{"q1": {"query": "SELECT 1;", "interval": 60}}`)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"a": 1}`, proposed.Options)
+	assert.Contains(t, proposed.Schedule, "q1")
+	proposed, err = environment.ProposedConfig(env, ActivityConfiguration, `{"options": {"a": 2}, "schedule": {}}`)
+	assert.NoError(t, err)
+	assert.True(t, sameSection(`{"a": 2}`, proposed.Options))
+	_, err = environment.ProposedConfig(env, ActivityCertificate, "")
+	assert.Error(t, err)
+	_, err = environment.ProposedConfig(env, ActivityConfiguration, "{")
+	assert.Error(t, err)
+}
+
+func TestConfigImpact(t *testing.T) {
+	environment := &Environment{}
+	active := map[string]int64{"darwin": 10, "ubuntu": 30, "windows": 5}
+	before := TLSEnvironment{
+		Options:  `{"a": 1}`,
+		Schedule: `{"q1": {"query": "SELECT 1;", "interval": 3600, "platform": "windows"}}`,
+		Packs:    `{}`,
+	}
+	// Format changes are not changes
+	after := before
+	after.Schedule = `{"q1":{"interval":3600,"platform":"windows","query":"SELECT 1;"}}`
+	impact, err := environment.ConfigImpact(before, after, active)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(45), impact.Active)
+	assert.Equal(t, int64(0), impact.Affected)
+	assert.Empty(t, impact.Sections)
+	// A query for darwin and ubuntu only reaches them, a query every 60 seconds runs 1440 times a day
+	after.Schedule = `{"q1": {"query": "SELECT 1;", "interval": 3600, "platform": "windows"}, "q2": {"query": "SELECT 2;", "interval": 60, "platform": "posix"}}`
+	impact, err = environment.ConfigImpact(before, after, active)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{ActivitySchedule}, impact.Sections)
+	assert.Equal(t, int64(40), impact.Affected)
+	assert.Equal(t, []PlatformImpact{{Platform: "ubuntu", Nodes: 30, Executions: 43200}, {Platform: "darwin", Nodes: 10, Executions: 14400}}, impact.Platforms)
+	assert.Equal(t, int64(57600), impact.Executions)
+	assert.False(t, impact.Heavy)
+	// Removing a sharded pack query reduces the volume
+	before.Packs = `{"pack1": {"platform": "darwin", "shard": 50, "queries": {"p1": {"query": "SELECT 3;", "interval": 10}}}}`
+	after = before
+	after.Packs = `{}`
+	after.Options = `{"a": 2}`
+	impact, err = environment.ConfigImpact(before, after, active)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{ActivityOptions, ActivityPacks}, impact.Sections)
+	assert.Equal(t, int64(45), impact.Affected)
+	assert.Equal(t, int64(-43200), impact.Executions)
+	// Heavy additions are flagged
+	after = before
+	after.Schedule = `{"q3": {"query": "SELECT 4;", "interval": 1}}`
+	impact, err = environment.ConfigImpact(before, after, active)
+	assert.NoError(t, err)
+	assert.True(t, impact.Heavy)
+	after.Schedule = "{"
+	_, err = environment.ConfigImpact(before, after, active)
+	assert.Error(t, err)
+}
+
+func TestParseImpact(t *testing.T) {
+	assert.Nil(t, ConfigVersion{}.ParseImpact())
+	impact := ConfigVersion{Impact: `{"affected_nodes": 12, "sections": ["packs"]}`}.ParseImpact()
+	assert.Equal(t, int64(12), impact.Affected)
+	assert.Equal(t, []string{ActivityPacks}, impact.Sections)
+}
@@ -0,0 +1,453 @@
+package environments
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmpsec/osctrl/backend"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// LineageProfile is the kind of the versioned inputs with the performance profile of a node
+	LineageProfile string = "profile"
+	// LineageEntries is the kind of the versioned inputs with the Windows event channels and denylist entries of a node
+	LineageEntries string = "entries"
+	// LineageOverrides is the kind of the versioned inputs with the schedule overrides of a node
+	LineageOverrides string = "overrides"
+	// DefaultLineageRetention is the default days to keep the configuration lineage of nodes
+	DefaultLineageRetention int = 90
+	// DefaultLineageInterval is the default interval to prune the configuration lineage of nodes
+	DefaultLineageInterval = 24 * time.Hour
+)
+
+// NodeInputs to hold what makes the configuration served to a node, on top of the configuration of its environment.
+// Channels are only used for Windows nodes and the denylist only has the entries that apply to the node.
+type NodeInputs struct {
+	Platform  string
+	Profile   PerformanceProfile
+	Channels  []WindowsEventChannel
+	Denylist  []ScheduleDeny
+	Overrides []ScheduleOverride
+}
+
+// ConfigInput to keep one versioned input of node configurations by the hash of its content, shared by all nodes
+type ConfigInput struct {
+	Hash      string `gorm:"primaryKey"`
+	Kind      string
+	Data      string
+	CreatedAt time.Time
+}
+
+// NodeConfigLineage to record when the configuration served to a node changed, by the versions of its inputs.
+// Configurations are not kept, they are rendered again from the environment version and the versioned inputs.
+type NodeConfigLineage struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	EnvironmentID uint      `gorm:"index" json:"environment_id"`
+	UUID          string    `gorm:"index" json:"uuid"`
+	ConfigVersion int       `json:"config_version"`
+	Profile       string    `json:"profile"`
+	ProfileHash   string    `json:"profile_hash"`
+	EntriesHash   string    `json:"entries_hash"`
+	OverridesHash string    `json:"overrides_hash"`
+	CreatedAt     time.Time `gorm:"index" json:"timestamp"`
+}
+
+// LineageDiff to hold the differences between the configurations served to a node at two points of its lineage
+type LineageDiff struct {
+	From NodeConfigLineage `json:"from"`
+	To   NodeConfigLineage `json:"to"`
+	Rows []DiffRow         `json:"rows"`
+}
+
+// ConfigHistory to hold the lineage of a node, with the differences between two of its points when they are compared
+type ConfigHistory struct {
+	Lineage []NodeConfigLineage `json:"lineage"`
+	Diff    *LineageDiff        `json:"diff,omitempty"`
+}
+
+// Compact inputs, only with the values used to render the configuration so hashes do not change with anything else
+type profileInput struct {
+	Name           string `json:"name"`
+	ConfigInterval int    `json:"config_interval"`
+	LogInterval    int    `json:"log_interval"`
+	QueryInterval  int    `json:"query_interval"`
+}
+
+type entriesInput struct {
+	Platform string                `json:"platform"`
+	Channels []WindowsEventSetting `json:"channels,omitempty"`
+	Denylist []DenyCandidate       `json:"denylist,omitempty"`
+}
+
+type overrideInput struct {
+	Query    string `json:"query"`
+	Interval int    `json:"interval"`
+}
+
+// Key to compare two points of the lineage of a node, ignoring when they were recorded
+func (l NodeConfigLineage) Key() string {
+	return fmt.Sprintf("%d/%d/%s/%s/%s/%s", l.EnvironmentID, l.ConfigVersion, l.Profile, l.ProfileHash, l.EntriesHash, l.OverridesHash)
+}
+
+// Helper to serialize one input and get its hash, empty inputs have no hash and are not kept
+func lineageInput(kind string, empty bool, v interface{}) (ConfigInput, bool) {
+	if empty {
+		return ConfigInput{}, false
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ConfigInput{}, false
+	}
+	sum := sha256.Sum256(append([]byte(kind+":"), data...))
+	return ConfigInput{Hash: hex.EncodeToString(sum[:8]), Kind: kind, Data: string(data)}, true
+}
+
+// NodeLineage to get the point of the lineage of a node for its inputs, with the versioned inputs to keep for it
+func NodeLineage(env TLSEnvironment, uuid string, inputs NodeInputs) (NodeConfigLineage, []ConfigInput) {
+	point := NodeConfigLineage{
+		EnvironmentID: env.ID,
+		UUID:          strings.ToUpper(uuid),
+		ConfigVersion: env.ConfigVersion,
+		Profile:       inputs.Profile.Name,
+	}
+	var keep []ConfigInput
+	profile := profileInput{
+		Name:           inputs.Profile.Name,
+		ConfigInterval: inputs.Profile.ConfigInterval,
+		LogInterval:    inputs.Profile.LogInterval,
+		QueryInterval:  inputs.Profile.QueryInterval,
+	}
+	if in, ok := lineageInput(LineageProfile, profile.Name == "", profile); ok {
+		point.ProfileHash = in.Hash
+		keep = append(keep, in)
+	}
+	entries := entriesInput{Platform: strings.ToLower(inputs.Platform)}
+	if strings.EqualFold(inputs.Platform, WindowsEventsPlatform) {
+		for _, c := range enabledWindowsChannels(inputs.Channels) {
+			entries.Channels = append(entries.Channels, WindowsEventSetting{Channel: c.Channel, Enabled: c.Enabled, Interval: c.Interval, MaxRows: c.MaxRows})
+		}
+	}
+	for _, d := range inputs.Denylist {
+		entries.Denylist = append(entries.Denylist, DenyCandidate{Pack: d.Pack, Query: d.Query})
+	}
+	if in, ok := lineageInput(LineageEntries, len(entries.Channels) == 0 && len(entries.Denylist) == 0, entries); ok {
+		point.EntriesHash = in.Hash
+		keep = append(keep, in)
+	}
+	var overrides []overrideInput
+	for _, o := range inputs.Overrides {
+		overrides = append(overrides, overrideInput{Query: o.Query, Interval: o.Interval})
+	}
+	if in, ok := lineageInput(LineageOverrides, len(overrides) == 0, overrides); ok {
+		point.OverridesHash = in.Hash
+		keep = append(keep, in)
+	}
+	return point, keep
+}
+
+// RenderNodeConfig to apply the inputs of a node to the configuration of its environment, already with its performance
+// profile applied. It is used to serve nodes and to render again the configurations in their lineage, so both match.
+// On error it returns the configuration with the inputs applied until then.
+func RenderNodeConfig(conf []byte, inputs NodeInputs) ([]byte, error) {
+	if strings.EqualFold(inputs.Platform, WindowsEventsPlatform) {
+		winConf, err := ApplyWindowsEvents(conf, inputs.Channels)
+		if err != nil {
+			return conf, fmt.Errorf("windows event channels %v", err)
+		}
+		conf = winConf
+	}
+	denyConf, err := ApplyScheduleDenylist(conf, inputs.Denylist, ProfileNode{Platform: inputs.Platform}, time.Now())
+	if err != nil {
+		return conf, fmt.Errorf("denylist %v", err)
+	}
+	conf = denyConf
+	overridesConf, err := ApplyScheduleOverrides(conf, inputs.Overrides)
+	if err != nil {
+		return conf, fmt.Errorf("schedule overrides %v", err)
+	}
+	return overridesConf, nil
+}
+
+// Helper to restore the inputs of a node from the versioned inputs of a point of its lineage
+func lineageInputs(point NodeConfigLineage, stored map[string]ConfigInput) (NodeInputs, error) {
+	var inputs NodeInputs
+	if point.ProfileHash != "" {
+		in, ok := stored[point.ProfileHash]
+		if !ok {
+			return inputs, fmt.Errorf("missing profile input %s", point.ProfileHash)
+		}
+		var p profileInput
+		if err := json.Unmarshal([]byte(in.Data), &p); err != nil {
+			return inputs, fmt.Errorf("Unmarshal profile %v", err)
+		}
+		inputs.Profile = PerformanceProfile{Name: p.Name, ConfigInterval: p.ConfigInterval, LogInterval: p.LogInterval, QueryInterval: p.QueryInterval}
+	}
+	if point.EntriesHash != "" {
+		in, ok := stored[point.EntriesHash]
+		if !ok {
+			return inputs, fmt.Errorf("missing entries input %s", point.EntriesHash)
+		}
+		var e entriesInput
+		if err := json.Unmarshal([]byte(in.Data), &e); err != nil {
+			return inputs, fmt.Errorf("Unmarshal entries %v", err)
+		}
+		inputs.Platform = e.Platform
+		for _, c := range e.Channels {
+			inputs.Channels = append(inputs.Channels, WindowsEventChannel{Channel: c.Channel, Enabled: c.Enabled, Interval: c.Interval, MaxRows: c.MaxRows})
+		}
+		for _, d := range e.Denylist {
+			inputs.Denylist = append(inputs.Denylist, ScheduleDeny{Pack: d.Pack, Query: d.Query})
+		}
+	}
+	if point.OverridesHash != "" {
+		in, ok := stored[point.OverridesHash]
+		if !ok {
+			return inputs, fmt.Errorf("missing overrides input %s", point.OverridesHash)
+		}
+		var overrides []overrideInput
+		if err := json.Unmarshal([]byte(in.Data), &overrides); err != nil {
+			return inputs, fmt.Errorf("Unmarshal overrides %v", err)
+		}
+		for _, o := range overrides {
+			inputs.Overrides = append(inputs.Overrides, ScheduleOverride{UUID: point.UUID, Query: o.Query, Interval: o.Interval})
+		}
+	}
+	return inputs, nil
+}
+
+// RenderLineage to render again the configuration served at one point of a lineage, from the configuration of the
+// environment in that version and the versioned inputs, with the same code path used to serve nodes
+func RenderLineage(configuration string, point NodeConfigLineage, stored map[string]ConfigInput) ([]byte, error) {
+	inputs, err := lineageInputs(point, stored)
+	if err != nil {
+		return nil, err
+	}
+	conf := []byte(configuration)
+	if inputs.Profile.Name != "" {
+		if conf, err = ApplyProfile(conf, inputs.Profile); err != nil {
+			return nil, fmt.Errorf("profile %v", err)
+		}
+	}
+	return RenderNodeConfig(conf, inputs)
+}
+
+// RecordLineage to add a point to the lineage of a node, keeping its versioned inputs if they are new
+func (environment *Environment) RecordLineage(point NodeConfigLineage, inputs []ConfigInput) error {
+	return environment.DB.Transaction(func(tx *gorm.DB) error {
+		if len(inputs) > 0 {
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&inputs).Error; err != nil {
+				return fmt.Errorf("Create inputs %v", err)
+			}
+		}
+		if err := tx.Create(&point).Error; err != nil {
+			return fmt.Errorf("Create %v", err)
+		}
+		return nil
+	})
+}
+
+// LastLineage to get the last point of the lineage of a node
+func (environment *Environment) LastLineage(uuid string) (NodeConfigLineage, error) {
+	var point NodeConfigLineage
+	if err := environment.DB.Where("uuid = ?", strings.ToUpper(uuid)).Order("created_at DESC, id DESC").First(&point).Error; err != nil {
+		return point, err
+	}
+	return point, nil
+}
+
+// Lineage to get the lineage of the configuration of a node, newest first
+func (environment *Environment) Lineage(uuid string) ([]NodeConfigLineage, error) {
+	var lineage []NodeConfigLineage
+	if err := environment.read().Where("uuid = ?", strings.ToUpper(uuid)).Order("created_at DESC, id DESC").Find(&lineage).Error; err != nil {
+		return lineage, err
+	}
+	return lineage, nil
+}
+
+// FindLineage to find a point by id in the lineage of a node
+func FindLineage(lineage []NodeConfigLineage, value string) (NodeConfigLineage, bool) {
+	id, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return NodeConfigLineage{}, false
+	}
+	for _, p := range lineage {
+		if p.ID == uint(id) {
+			return p, true
+		}
+	}
+	return NodeConfigLineage{}, false
+}
+
+// LineagePoints to get the two points of the lineage of a node to compare, the latest and the one before by default
+func LineagePoints(lineage []NodeConfigLineage, from, to string) (NodeConfigLineage, NodeConfigLineage) {
+	t, ok := FindLineage(lineage, to)
+	if !ok && len(lineage) > 0 {
+		t = lineage[0]
+	}
+	f, ok := FindLineage(lineage, from)
+	if !ok {
+		f = t
+		if len(lineage) > 1 {
+			f = lineage[1]
+		}
+	}
+	return f, t
+}
+
+// Helper to get the configuration of an environment in a version. Points recorded before versioning use the first
+// version, which keeps the configuration from then, or the current configuration if there are no versions.
+func (environment *Environment) versionConfiguration(envid uint, version int) (string, error) {
+	if version == 0 {
+		version = 1
+	}
+	v, err := environment.GetConfigVersion(envid, version)
+	if err == nil {
+		return v.Configuration, nil
+	}
+	if version != 1 || err != gorm.ErrRecordNotFound {
+		return "", fmt.Errorf("version %d - %v", version, err)
+	}
+	var env TLSEnvironment
+	if err := environment.read().First(&env, envid).Error; err != nil {
+		return "", err
+	}
+	return env.Configuration, nil
+}
+
+// RenderLineagePoint to render again the configuration served to a node at one point of its lineage
+func (environment *Environment) RenderLineagePoint(point NodeConfigLineage) ([]byte, error) {
+	configuration, err := environment.versionConfiguration(point.EnvironmentID, point.ConfigVersion)
+	if err != nil {
+		return nil, err
+	}
+	var hashes []string
+	for _, h := range []string{point.ProfileHash, point.EntriesHash, point.OverridesHash} {
+		if h != "" {
+			hashes = append(hashes, h)
+		}
+	}
+	stored := make(map[string]ConfigInput)
+	if len(hashes) > 0 {
+		var inputs []ConfigInput
+		if err := environment.read().Where("hash IN ?", hashes).Find(&inputs).Error; err != nil {
+			return nil, err
+		}
+		for _, in := range inputs {
+			stored[in.Hash] = in
+		}
+	}
+	return RenderLineage(configuration, point, stored)
+}
+
+// DiffLineage to render again the configurations served to a node at two points of its lineage and compare them
+func (environment *Environment) DiffLineage(from, to NodeConfigLineage) (LineageDiff, error) {
+	diff := LineageDiff{From: from, To: to}
+	before, err := environment.RenderLineagePoint(from)
+	if err != nil {
+		return diff, fmt.Errorf("error rendering %d - %v", from.ID, err)
+	}
+	after, err := environment.RenderLineagePoint(to)
+	if err != nil {
+		return diff, fmt.Errorf("error rendering %d - %v", to.ID, err)
+	}
+	diff.Rows = SideBySide(DiffLines(indentJSON(before), indentJSON(after)))
+	return diff, nil
+}
+
+// Helper to indent a serialized configuration so diffs are by line, kept as it is if it is not valid JSON
+func indentJSON(data []byte) string {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return string(data)
+	}
+	indented, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return string(data)
+	}
+	return string(indented)
+}
+
+// PruneLineage to delete the points of lineages older than the retention in days, 0 keeps them forever. The last
+// point of each node before the cutoff is kept, so its configuration since then can still be rendered. Versioned
+// inputs not used by any point are deleted too.
+func (environment *Environment) PruneLineage(now time.Time, retention int) error {
+	if retention <= 0 {
+		return nil
+	}
+	cutoff := now.AddDate(0, 0, -retention)
+	return environment.DB.Transaction(func(tx *gorm.DB) error {
+		var old []NodeConfigLineage
+		if err := tx.Where("created_at < ?", cutoff).Order("created_at, id").Find(&old).Error; err != nil {
+			return fmt.Errorf("Find %v", err)
+		}
+		last := make(map[string]uint)
+		for _, p := range old {
+			last[p.UUID] = p.ID
+		}
+		var ids []uint
+		for _, p := range old {
+			if last[p.UUID] != p.ID {
+				ids = append(ids, p.ID)
+			}
+		}
+		if len(ids) > 0 {
+			if err := tx.Where("id IN ?", ids).Delete(&NodeConfigLineage{}).Error; err != nil {
+				return fmt.Errorf("Delete %v", err)
+			}
+		}
+		used := tx.Model(&NodeConfigLineage{})
+		err := tx.Where("hash NOT IN (?) AND hash NOT IN (?) AND hash NOT IN (?)",
+			used.Session(&gorm.Session{}).Select("profile_hash"),
+			used.Session(&gorm.Session{}).Select("entries_hash"),
+			used.Session(&gorm.Session{}).Select("overrides_hash"),
+		).Delete(&ConfigInput{}).Error
+		if err != nil {
+			return fmt.Errorf("Delete inputs %v", err)
+		}
+		return nil
+	})
+}
+
+// StartLineagePrune to prune the configuration lineage of nodes periodically, only by the instance holding the leader lock
+func (environment *Environment) StartLineagePrune(ctx context.Context, leader *backend.LeaderLock, interval time.Duration, retention func() int) {
+	if interval <= 0 {
+		interval = DefaultLineageInterval
+	}
+	run := func() {
+		if leader != nil {
+			isLeader, err := leader.Acquire()
+			if err != nil {
+				log.Printf("error acquiring leader lock %v", err)
+				return
+			}
+			if !isLeader {
+				return
+			}
+		}
+		if err := environment.PruneLineage(time.Now(), retention()); err != nil {
+			log.Printf("error pruning configuration lineage %v", err)
+		}
+	}
+	go func() {
+		run()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				run()
+			}
+		}
+	}()
+}
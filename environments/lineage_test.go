@@ -0,0 +1,103 @@
+package environments
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func lineageStored(inputs []ConfigInput) map[string]ConfigInput {
+	stored := make(map[string]ConfigInput)
+	for _, in := range inputs {
+		stored[in.Hash] = in
+	}
+	return stored
+}
+
+func TestNodeLineageEmptyInputs(t *testing.T) {
+	env := TLSEnvironment{Configuration: testDenylistConf, ConfigVersion: 3}
+	env.ID = 1
+	point, keep := NodeLineage(env, "aaa", NodeInputs{Platform: "ubuntu"})
+	assert.Equal(t, "AAA", point.UUID)
+	assert.Equal(t, 3, point.ConfigVersion)
+	assert.Empty(t, point.ProfileHash)
+	assert.Empty(t, point.EntriesHash)
+	assert.Empty(t, point.OverridesHash)
+	assert.Empty(t, keep)
+	// Windows event channels are only inputs of Windows nodes
+	channels := []WindowsEventChannel{{Channel: "Security", Enabled: true, Interval: 60, MaxRows: 100}}
+	point, keep = NodeLineage(env, "aaa", NodeInputs{Platform: "ubuntu", Channels: channels})
+	assert.Empty(t, point.EntriesHash)
+	assert.Empty(t, keep)
+}
+
+func TestNodeLineageKey(t *testing.T) {
+	env := TLSEnvironment{Configuration: testDenylistConf, ConfigVersion: 3}
+	inputs := NodeInputs{
+		Platform:  "ubuntu",
+		Profile:   PerformanceProfile{Name: "servers", ConfigInterval: 600},
+		Denylist:  []ScheduleDeny{{Query: "processes", Reason: "too expensive", Creator: "admin"}},
+		Overrides: []ScheduleOverride{{UUID: "AAA", Query: "uptime", Interval: 30}},
+	}
+	point, keep := NodeLineage(env, "AAA", inputs)
+	assert.Len(t, keep, 3)
+	// Hashes only depend on what is rendered, not on reasons or who created the entries
+	inputs.Denylist = []ScheduleDeny{{Query: "processes", Reason: "still too expensive", Creator: "other"}}
+	same, _ := NodeLineage(env, "AAA", inputs)
+	assert.Equal(t, point.Key(), same.Key())
+	// Any change of the inputs or the version is a new point
+	inputs.Overrides = []ScheduleOverride{{UUID: "AAA", Query: "uptime", Interval: 60}}
+	changed, _ := NodeLineage(env, "AAA", inputs)
+	assert.NotEqual(t, point.Key(), changed.Key())
+	assert.Equal(t, point.EntriesHash, changed.EntriesHash)
+	env.ConfigVersion = 4
+	changed, _ = NodeLineage(env, "AAA", NodeInputs{Platform: "ubuntu"})
+	assert.NotEqual(t, point.Key(), changed.Key())
+}
+
+func TestRenderLineageMatchesServed(t *testing.T) {
+	env := TLSEnvironment{Configuration: testDenylistConf, ConfigVersion: 2}
+	profiles := []PerformanceProfile{{Name: "servers", Platform: "windows", ConfigInterval: 900, LogInterval: 120}}
+	channels := []WindowsEventChannel{{Channel: "Security", Enabled: true, Interval: 60, MaxRows: 100}, {Channel: "System", Interval: 60}}
+	denylist := []ScheduleDeny{{Pack: "incident", Query: "sockets", Reason: "slow"}, {Query: "processes", Platform: "darwin", Reason: "slow"}}
+	overrides := []ScheduleOverride{{UUID: "AAA", Query: "uptime", Interval: 30}}
+	node := ProfileNode{Platform: "windows"}
+	served, name, err := NodeConfiguration(env, profiles, channels, denylist, overrides, node)
+	assert.NoError(t, err)
+	assert.Equal(t, "servers", name)
+	// The inputs recorded for the node render again the same configuration
+	inputs := NodeInputs{
+		Platform:  node.Platform,
+		Profile:   profiles[0],
+		Channels:  channels,
+		Denylist:  NodeDenylist(denylist, node, time.Now()),
+		Overrides: overrides,
+	}
+	point, keep := NodeLineage(env, "AAA", inputs)
+	rendered, err := RenderLineage(env.Configuration, point, lineageStored(keep))
+	assert.NoError(t, err)
+	assert.Equal(t, string(served), string(rendered))
+	parsed := parseDenyConf(t, rendered)
+	assert.Equal(t, float64(900), parsed.Options["config_refresh"])
+	assert.Contains(t, parsed.Schedule, "processes")
+	assert.NotContains(t, parsed.Pack["incident"], "sockets")
+	// Missing inputs can not be rendered
+	_, err = RenderLineage(env.Configuration, point, nil)
+	assert.Error(t, err)
+}
+
+func TestLineagePoints(t *testing.T) {
+	lineage := []NodeConfigLineage{{ID: 7}, {ID: 5}, {ID: 2}}
+	from, to := LineagePoints(lineage, "", "")
+	assert.Equal(t, uint(5), from.ID)
+	assert.Equal(t, uint(7), to.ID)
+	from, to = LineagePoints(lineage, "2", "5")
+	assert.Equal(t, uint(2), from.ID)
+	assert.Equal(t, uint(5), to.ID)
+	_, ok := FindLineage(lineage, "3")
+	assert.False(t, ok)
+	from, to = LineagePoints(lineage[:1], "", "")
+	assert.Equal(t, uint(7), from.ID)
+	assert.Equal(t, uint(7), to.ID)
+}
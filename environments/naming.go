@@ -0,0 +1,20 @@
+package environments
+
+import (
+	"fmt"
+	"strings"
+)
+
+// UpdateNaming to update the naming policy of the nodes of an environment: lowercase names, comma separated
+// domain suffixes to strip, and if the FQDN is preferred over the localname
+func (environment *Environment) UpdateNaming(idEnv string, lowercase bool, domains string, preferFQDN bool) error {
+	values := map[string]interface{}{
+		"naming_lowercase":   lowercase,
+		"naming_domains":     strings.TrimSpace(domains),
+		"naming_prefer_fqdn": preferFQDN,
+	}
+	if err := environment.DB.Model(&TLSEnvironment{}).Where("name = ? OR uuid = ?", idEnv, idEnv).Updates(values).Error; err != nil {
+		return fmt.Errorf("UpdateNaming %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package environments
+
+import (
+	"time"
+)
+
+// NodeConfiguration to render the configuration served to a node, with conflicts resolved by precedence, its performance
+// profile, the Windows event channels when it is a Windows node, the denylist entries that apply to it and its schedule
+// overrides. Storm backoff and quarantine are temporary and they are not included. Returns the name of the profile of the
+// node, empty if it has none.
+func NodeConfiguration(env TLSEnvironment, profiles []PerformanceProfile, channels []WindowsEventChannel, denylist []ScheduleDeny, overrides []ScheduleOverride, node ProfileNode) ([]byte, string, error) {
+	var name string
+	conf, err := ResolveConflicts([]byte(env.Configuration))
+	if err != nil {
+		return conf, name, err
+	}
+	if profile, ok := MatchProfile(profiles, node); ok {
+		profileConf, err := ApplyProfile(conf, profile)
+		if err != nil {
+			return conf, name, err
+		}
+		conf = profileConf
+		name = profile.Name
+	}
+	inputs := NodeInputs{
+		Platform:  node.Platform,
+		Channels:  channels,
+		Denylist:  NodeDenylist(denylist, node, time.Now()),
+		Overrides: overrides,
+	}
+	nodeConf, err := RenderNodeConfig(conf, inputs)
+	if err != nil {
+		return conf, name, err
+	}
+	return nodeConf, name, nil
+}
@@ -0,0 +1,34 @@
+package environments
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNodeConfiguration(t *testing.T) {
+	env := TLSEnvironment{Configuration: `{"options":{"config_refresh":60},"schedule":{"uptime":{"query":"SELECT * FROM uptime;","interval":300}}}`}
+	channels := []WindowsEventChannel{{Channel: "System", Enabled: true}}
+	overrides := []ScheduleOverride{{UUID: "AAA", Query: "uptime", Interval: 30}}
+	conf, profile, err := NodeConfiguration(env, testProfiles, channels, nil, overrides, ProfileNode{Platform: "ubuntu"})
+	assert.NoError(t, err)
+	assert.Equal(t, "server", profile)
+	var parsed struct {
+		Options  map[string]interface{}            `json:"options"`
+		Schedule map[string]map[string]interface{} `json:"schedule"`
+	}
+	assert.NoError(t, json.Unmarshal(conf, &parsed))
+	assert.Equal(t, float64(300), parsed.Options["config_refresh"])
+	assert.Equal(t, float64(30), parsed.Schedule["uptime"]["interval"])
+	assert.Len(t, parsed.Schedule, 1)
+	// Windows nodes get the queries of the channels, nodes without profile keep the environment intervals
+	conf, profile, err = NodeConfiguration(env, testProfiles, channels, nil, nil, ProfileNode{Platform: "windows"})
+	assert.NoError(t, err)
+	assert.Equal(t, "", profile)
+	parsed.Schedule = nil
+	assert.NoError(t, json.Unmarshal(conf, &parsed))
+	assert.Equal(t, float64(60), parsed.Options["config_refresh"])
+	assert.Len(t, parsed.Schedule, 2)
+	assert.Equal(t, float64(300), parsed.Schedule["uptime"]["interval"])
+}
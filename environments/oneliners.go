@@ -6,8 +6,8 @@ import (
 	"strings"
 	"text/template"
 
+	"github.com/jmpsec/osctrl/locales"
 	"github.com/jmpsec/osctrl/settings"
-	"github.com/jmpsec/osctrl/version"
 )
 
 const (
@@ -119,6 +119,20 @@ iex ((New-Object System.Net.WebClient).DownloadString('https://{{ .TLSHost }}/{{
 	return PrepareOneLiner(s, insecure, environment, RemovePowershell)
 }
 
+// Version of osquery installed by the scripts in a platform, and if it is pinned
+type scriptVersion struct {
+	Version string
+	Pinned  bool
+}
+
+// Helper to get the version of osquery installed by the scripts of an environment in a platform
+func newScriptVersion(env TLSEnvironment, platform string) scriptVersion {
+	return scriptVersion{
+		Version: ScriptVersion(env, platform),
+		Pinned:  PinnedVersion(env, platform) != "",
+	}
+}
+
 // QuickAddScript to get a quick add script for a environment
 func QuickAddScript(project, script string, environment TLSEnvironment) (string, error) {
 	if !validScript[script] {
@@ -140,20 +154,26 @@ func QuickAddScript(project, script string, environment TLSEnvironment) (string,
 		templateName = TemplateRemovePowershell
 		templateScript = QuickRemoveScriptPowershell
 	}
-	// Prepare template
-	t, err := template.New(templateName).Parse(templateScript)
+	// Prepare template, messages use the locale of the environment
+	t, err := template.New(templateName).Funcs(locales.FuncMap(environment.Locale)).Parse(templateScript)
 	if err != nil {
 		return "", err
 	}
 	// Prepare template data
 	data := struct {
-		Project        string
-		OsqueryVersion string
-		Environment    TLSEnvironment
+		Project     string
+		Versions    map[string]scriptVersion
+		PackagesURL string
+		Environment TLSEnvironment
 	}{
-		Project:        project,
-		OsqueryVersion: version.OsqueryVersion,
-		Environment:    environment,
+		Project: project,
+		Versions: map[string]scriptVersion{
+			"Linux":   newScriptVersion(environment, "linux"),
+			"Darwin":  newScriptVersion(environment, "darwin"),
+			"Windows": newScriptVersion(environment, "windows"),
+		},
+		PackagesURL: OsqueryPackagesURL,
+		Environment: environment,
 	}
 	// Compile template into buffer
 	var tpl bytes.Buffer
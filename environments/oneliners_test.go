@@ -54,3 +54,26 @@ func TestPrepareOneLiner(t *testing.T) {
 		assert.Equal(t, oneliner, "oneliner  1 hostname 2 name 3 rPath")
 	})
 }
+
+func TestQuickAddScriptLocale(t *testing.T) {
+	env := TLSEnvironment{Name: "dev", Secret: "secret"}
+	for _, script := range []string{EnrollShell, EnrollPowershell, RemoveShell, RemovePowershell} {
+		english, err := QuickAddScript("osctrl-dev", script, env)
+		assert.NoError(t, err)
+		env.Locale = "es"
+		spanish, err := QuickAddScript("osctrl-dev", script, env)
+		assert.NoError(t, err)
+		env.Locale = ""
+		assert.NotEqual(t, english, spanish, "script %s", script)
+		assert.Contains(t, spanish, "¡Felicidades!", "script %s", script)
+	}
+	enroll, err := QuickAddScript("osctrl-dev", EnrollShell, env)
+	assert.NoError(t, err)
+	assert.Contains(t, enroll, `log "Congratulations! The node has been enrolled in $_PROJECT"`)
+	assert.Contains(t, enroll, "# osctrl-dev - Tool to quick-add OSX/Linux nodes")
+	// Unknown locales fallback to English
+	env.Locale = "xx"
+	fallback, err := QuickAddScript("osctrl-dev", EnrollShell, env)
+	assert.NoError(t, err)
+	assert.Equal(t, enroll, fallback)
+}
@@ -70,25 +70,37 @@ func (environment *Environment) RefreshConfiguration(idEnv string) error {
 	if err != nil {
 		return fmt.Errorf("error structuring environment %v", err)
 	}
+	indentedConf, err := environment.GenConfiguration(env)
+	if err != nil {
+		return err
+	}
+	if err := environment.DB.Model(&env).Update("configuration", indentedConf).Error; err != nil {
+		return fmt.Errorf("Update configuration %v", err)
+	}
+	return nil
+}
+
+// GenConfiguration to generate the full configuration of an environment from its parts
+func (environment *Environment) GenConfiguration(env TLSEnvironment) (string, error) {
 	_options, err := environment.GenStructOptions([]byte(env.Options))
 	if err != nil {
-		return fmt.Errorf("error structuring options %v", err)
+		return "", fmt.Errorf("error structuring options %v", err)
 	}
 	_schedule, err := environment.GenStructSchedule([]byte(env.Schedule))
 	if err != nil {
-		return fmt.Errorf("error structuring schedule %v", err)
+		return "", fmt.Errorf("error structuring schedule %v", err)
 	}
 	_packs, err := environment.GenStructPacks([]byte(env.Packs))
 	if err != nil {
-		return fmt.Errorf("error structuring packs %v", err)
+		return "", fmt.Errorf("error structuring packs %v", err)
 	}
 	_decorators, err := environment.GenStructDecorators([]byte(env.Decorators))
 	if err != nil {
-		return fmt.Errorf("error structuring decorators %v", err)
+		return "", fmt.Errorf("error structuring decorators %v", err)
 	}
 	_ATC, err := environment.GenStructATC([]byte(env.ATC))
 	if err != nil {
-		return fmt.Errorf("error structuring ATC %v", err)
+		return "", fmt.Errorf("error structuring ATC %v", err)
 	}
 	conf := OsqueryConf{
 		Options:    _options,
@@ -99,12 +111,9 @@ func (environment *Environment) RefreshConfiguration(idEnv string) error {
 	}
 	indentedConf, err := environment.GenSerializedConf(conf, true)
 	if err != nil {
-		return fmt.Errorf("error serializing configuration %v", err)
+		return "", fmt.Errorf("error serializing configuration %v", err)
 	}
-	if err := environment.DB.Model(&env).Update("configuration", indentedConf).Error; err != nil {
-		return fmt.Errorf("Update configuration %v", err)
-	}
-	return nil
+	return indentedConf, nil
 }
 
 // UpdateConfiguration to update configuration for an environment
@@ -0,0 +1,178 @@
+package environments
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jmpsec/osctrl/version"
+)
+
+const (
+	// OsqueryPackagesURL is the base URL of the official osquery packages
+	OsqueryPackagesURL string = "https://osquery-packages.s3.amazonaws.com"
+	// PackageDeb for Debian based linux distributions
+	PackageDeb string = "deb"
+	// PackageRpm for RPM based linux distributions
+	PackageRpm string = "rpm"
+	// PackagePkg for macOS
+	PackagePkg string = "pkg"
+	// PackageMsi for Windows
+	PackageMsi string = "msi"
+)
+
+// Valid pinned osquery versions, like 5.6.0
+var osqueryVersionRegexp = regexp.MustCompile(`^[0-9]+\.[0-9]+\.[0-9]+$`)
+
+// OsqueryPackage to describe the package of osquery installed in a platform by the enroll scripts
+type OsqueryPackage struct {
+	Platform string `json:"platform"`
+	Kind     string `json:"kind"`
+	Version  string `json:"version"`
+	URL      string `json:"url"`
+}
+
+// ValidOsqueryVersion to check if a version can be pinned
+func ValidOsqueryVersion(v string) bool {
+	return osqueryVersionRegexp.MatchString(v)
+}
+
+// CompareVersions to compare two osquery versions by their numeric parts, returning -1, 0 or 1.
+// Anything after the numbers, like -1-g8a3f or a build suffix, is ignored.
+func CompareVersions(a, b string) int {
+	pa := versionParts(a)
+	pb := versionParts(b)
+	for i := 0; i < 3; i++ {
+		if pa[i] < pb[i] {
+			return -1
+		}
+		if pa[i] > pb[i] {
+			return 1
+		}
+	}
+	return 0
+}
+
+// Helper to get the major, minor and patch numbers of a version
+func versionParts(v string) [3]int {
+	var parts [3]int
+	for i, p := range strings.SplitN(strings.TrimSpace(v), ".", 3) {
+		end := 0
+		for end < len(p) && p[end] >= '0' && p[end] <= '9' {
+			end++
+		}
+		parts[i], _ = strconv.Atoi(p[:end])
+	}
+	return parts
+}
+
+// ParseOsqueryPins to parse the per-platform pinned versions as a comma separated list of platform=version
+func ParseOsqueryPins(list string) (map[string]string, error) {
+	pins := make(map[string]string)
+	for _, spec := range strings.Split(list, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		i := strings.Index(spec, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid pin %q, use platform=version", spec)
+		}
+		platform := strings.ToLower(strings.TrimSpace(spec[:i]))
+		v := strings.TrimSpace(spec[i+1:])
+		if !platformRegexp.MatchString(platform) {
+			return nil, fmt.Errorf("invalid platform %s", platform)
+		}
+		if !ValidOsqueryVersion(v) {
+			return nil, fmt.Errorf("invalid osquery version %s for %s", v, platform)
+		}
+		pins[platform] = v
+	}
+	return pins, nil
+}
+
+// FormatOsqueryPins to serialize per-platform pinned versions, sorted by platform
+func FormatOsqueryPins(pins map[string]string) string {
+	list := make([]string, 0, len(pins))
+	for p, v := range pins {
+		list = append(list, p+"="+v)
+	}
+	sort.Strings(list)
+	return strings.Join(list, ",")
+}
+
+// PinnedVersion to get the osquery version pinned for a platform in an environment, empty when not pinned.
+// Overrides for the platform win over the pin of the environment, linux overrides apply to all distributions.
+func PinnedVersion(env TLSEnvironment, platform string) string {
+	pins, _ := ParseOsqueryPins(env.OsqueryPins)
+	platform = strings.ToLower(platform)
+	if v, ok := pins[platform]; ok {
+		return v
+	}
+	if v, ok := pins["linux"]; ok && IsPlatformLinux(platform) {
+		return v
+	}
+	return env.OsqueryPin
+}
+
+// ScriptVersion to get the osquery version installed by the scripts of an environment in a platform
+func ScriptVersion(env TLSEnvironment, platform string) string {
+	if v := PinnedVersion(env, platform); v != "" {
+		return v
+	}
+	return version.OsqueryVersion
+}
+
+// OffPin to check if a node runs a different osquery version than the one pinned for its platform
+func OffPin(env TLSEnvironment, platform, osqueryVersion string) bool {
+	pinned := PinnedVersion(env, platform)
+	return pinned != "" && osqueryVersion != "" && osqueryVersion != pinned
+}
+
+// ReferenceVersion to get the oldest osquery version nodes of an environment should run, for flags validation.
+// Without pin, it is the version installed by the scripts.
+func ReferenceVersion(env TLSEnvironment) string {
+	reference := ScriptVersion(env, "")
+	pins, _ := ParseOsqueryPins(env.OsqueryPins)
+	for _, v := range pins {
+		if CompareVersions(v, reference) < 0 {
+			reference = v
+		}
+	}
+	return reference
+}
+
+// OsqueryPackages to get the packages of osquery installed by the scripts of an environment
+func OsqueryPackages(env TLSEnvironment) []OsqueryPackage {
+	linux := ScriptVersion(env, "linux")
+	darwin := ScriptVersion(env, "darwin")
+	windows := ScriptVersion(env, "windows")
+	return []OsqueryPackage{
+		{Platform: "linux", Kind: PackageDeb, Version: linux, URL: OsqueryPackagesURL + "/deb/osquery_" + linux + "-1.linux_amd64.deb"},
+		{Platform: "linux", Kind: PackageRpm, Version: linux, URL: OsqueryPackagesURL + "/rpm/osquery-" + linux + "-1.linux.x86_64.rpm"},
+		{Platform: "darwin", Kind: PackagePkg, Version: darwin, URL: OsqueryPackagesURL + "/darwin/osquery-" + darwin + ".pkg"},
+		{Platform: "windows", Kind: PackageMsi, Version: windows, URL: OsqueryPackagesURL + "/windows/osquery-" + windows + ".msi"},
+	}
+}
+
+// UpdateOsqueryPin to update the pinned osquery version of an environment and its per-platform overrides, empty removes the pin
+func (environment *Environment) UpdateOsqueryPin(idEnv, pin, pins string) error {
+	pin = strings.TrimSpace(pin)
+	if pin != "" && !ValidOsqueryVersion(pin) {
+		return fmt.Errorf("invalid osquery version %s", pin)
+	}
+	parsed, err := ParseOsqueryPins(pins)
+	if err != nil {
+		return err
+	}
+	updates := map[string]interface{}{
+		"osquery_pin":  pin,
+		"osquery_pins": FormatOsqueryPins(parsed),
+	}
+	if err := environment.DB.Model(&TLSEnvironment{}).Where("name = ? OR uuid = ?", idEnv, idEnv).Updates(updates).Error; err != nil {
+		return fmt.Errorf("UpdateOsqueryPin %v", err)
+	}
+	return nil
+}
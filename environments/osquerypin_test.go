@@ -0,0 +1,84 @@
+package environments
+
+import (
+	"testing"
+
+	"github.com/jmpsec/osctrl/version"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareVersions(t *testing.T) {
+	assert.Equal(t, 0, CompareVersions("5.6.0", "5.6.0"))
+	assert.Equal(t, -1, CompareVersions("5.5.1", "5.6.0"))
+	assert.Equal(t, 1, CompareVersions("5.10.0", "5.9.2"))
+	assert.Equal(t, 0, CompareVersions("5.6.0-1-g8a3f", "5.6.0"))
+	assert.True(t, ValidOsqueryVersion("5.6.0"))
+	assert.False(t, ValidOsqueryVersion("5.6"))
+	assert.False(t, ValidOsqueryVersion("latest"))
+}
+
+func TestParseOsqueryPins(t *testing.T) {
+	pins, err := ParseOsqueryPins(" Windows=5.5.1,, darwin = 5.6.0 ")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"windows": "5.5.1", "darwin": "5.6.0"}, pins)
+	assert.Equal(t, "darwin=5.6.0,windows=5.5.1", FormatOsqueryPins(pins))
+	_, err = ParseOsqueryPins("windows")
+	assert.Error(t, err)
+	_, err = ParseOsqueryPins("windows=latest")
+	assert.Error(t, err)
+}
+
+func TestPinnedVersion(t *testing.T) {
+	env := TLSEnvironment{}
+	assert.Equal(t, "", PinnedVersion(env, "ubuntu"))
+	assert.Equal(t, version.OsqueryVersion, ScriptVersion(env, "ubuntu"))
+	assert.False(t, OffPin(env, "ubuntu", "4.9.0"))
+	env.OsqueryPin = "5.6.0"
+	env.OsqueryPins = "linux=5.5.1,windows=5.4.0"
+	assert.Equal(t, "5.5.1", PinnedVersion(env, "ubuntu"))
+	assert.Equal(t, "5.5.1", PinnedVersion(env, "linux"))
+	assert.Equal(t, "5.4.0", PinnedVersion(env, "Windows"))
+	assert.Equal(t, "5.6.0", PinnedVersion(env, "darwin"))
+	assert.True(t, OffPin(env, "darwin", "5.5.1"))
+	assert.False(t, OffPin(env, "centos", "5.5.1"))
+	assert.False(t, OffPin(env, "darwin", ""))
+	assert.Equal(t, "5.4.0", ReferenceVersion(env))
+	packages := OsqueryPackages(env)
+	assert.Equal(t, 4, len(packages))
+	assert.Equal(t, OsqueryPackagesURL+"/deb/osquery_5.5.1-1.linux_amd64.deb", packages[0].URL)
+	assert.Equal(t, OsqueryPackagesURL+"/windows/osquery-5.4.0.msi", packages[3].URL)
+}
+
+func TestQuickAddScriptPinned(t *testing.T) {
+	env := TLSEnvironment{Name: "prod", Secret: "secret", OsqueryPin: "5.5.1", OsqueryPins: "windows=5.4.0"}
+	shell, err := QuickAddScript("osctrl-prod", EnrollShell, env)
+	assert.NoError(t, err)
+	assert.Contains(t, shell, `_OSQUERY_VER_LINUX="5.5.1"`)
+	assert.Contains(t, shell, `_OSQUERY_VER_DARWIN="5.5.1"`)
+	assert.Contains(t, shell, `_OSQUERY_PINNED_LINUX="1"`)
+	assert.Contains(t, shell, OsqueryPackagesURL+"/rpm/osquery-$_OSQUERY_VER_LINUX-1.linux.x86_64.rpm")
+	powershell, err := QuickAddScript("osctrl-prod", EnrollPowershell, env)
+	assert.NoError(t, err)
+	assert.Contains(t, powershell, `$osqueryVersion = "5.4.0"`)
+	assert.Contains(t, powershell, `$osqueryPinned = $true`)
+	// Without pin, scripts install the default version when osquery is missing or older
+	env.OsqueryPin = ""
+	env.OsqueryPins = ""
+	shell, err = QuickAddScript("osctrl-prod", EnrollShell, env)
+	assert.NoError(t, err)
+	assert.Contains(t, shell, `_OSQUERY_VER_LINUX="`+version.OsqueryVersion+`"`)
+	assert.Contains(t, shell, `_OSQUERY_PINNED_LINUX=""`)
+}
+
+func TestLintFlags(t *testing.T) {
+	flags := "--host_identifier=uuid\n# --enable_bpf_events=true\n--disable_endpointsecurity=false\n--disable_endpointsecurity_fim=false\n"
+	assert.Equal(t, []string{"--disable_endpointsecurity_fim requires osquery 5.3.0, the reference version is 4.9.0"}, LintFlags(flags, "4.9.0"))
+	assert.Equal(t, []string{
+		"--disable_endpointsecurity requires osquery 4.7.0, the reference version is 4.6.0",
+		"--disable_endpointsecurity_fim requires osquery 5.3.0, the reference version is 4.6.0",
+	}, LintFlags(flags, "4.6.0"))
+	assert.Empty(t, LintFlags(flags, "5.6.0"))
+	assert.Empty(t, LintFlags(flags, ""))
+	env := TLSEnvironment{Flags: flags, OsqueryPin: "5.6.0", OsqueryPins: "darwin=5.2.0"}
+	assert.Equal(t, 1, len(LintFlagsEnv(env)))
+}
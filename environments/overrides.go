@@ -0,0 +1,204 @@
+package environments
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// MaxOverrideDuration is the longest time a schedule override can be active
+	MaxOverrideDuration = 7 * 24 * time.Hour
+	// PackQueryPrefix is how osquery names the queries from packs, pack_<pack>_<query>
+	PackQueryPrefix = "pack_"
+)
+
+// ScheduleOverride to change temporarily the interval of one scheduled query for one node
+type ScheduleOverride struct {
+	gorm.Model
+	EnvironmentID uint   `gorm:"index"`
+	UUID          string `gorm:"index"`
+	Query         string
+	Interval      int
+	Creator       string
+	ExpiresAt     time.Time
+}
+
+// PackQueryName to generate the name osquery uses for a query inside a pack
+func PackQueryName(pack, query string) string {
+	return PackQueryPrefix + pack + "_" + query
+}
+
+// NodeScheduleNames to get the names of all the scheduled and pack queries that apply to a platform
+func (environment *Environment) NodeScheduleNames(env TLSEnvironment, platform string) (map[string]bool, error) {
+	names := make(map[string]bool)
+	schedule, err := environment.NodeStructSchedule([]byte(env.Schedule), platform)
+	if err != nil {
+		return names, err
+	}
+	for q := range schedule {
+		names[q] = true
+	}
+	packs, err := environment.NodePacksEntries([]byte(env.Packs), platform)
+	if err != nil {
+		return names, err
+	}
+	for p, pack := range packs {
+		for q := range pack.Queries {
+			names[PackQueryName(p, q)] = true
+		}
+	}
+	return names, nil
+}
+
+// SetScheduleOverride to create a new schedule override for a node, the query must be in the node configuration
+func (environment *Environment) SetScheduleOverride(env TLSEnvironment, uuid, platform, query string, interval int, expires time.Time, creator string) (ScheduleOverride, error) {
+	var override ScheduleOverride
+	if interval <= 0 {
+		return override, fmt.Errorf("invalid interval %d", interval)
+	}
+	now := time.Now()
+	if !expires.After(now) {
+		return override, fmt.Errorf("expiration must be in the future")
+	}
+	if expires.Sub(now) > MaxOverrideDuration {
+		return override, fmt.Errorf("expiration can not be longer than %s", MaxOverrideDuration)
+	}
+	names, err := environment.NodeScheduleNames(env, platform)
+	if err != nil {
+		return override, fmt.Errorf("NodeScheduleNames %v", err)
+	}
+	if !names[query] {
+		return override, fmt.Errorf("query %s is not scheduled for this node", query)
+	}
+	override = ScheduleOverride{
+		EnvironmentID: env.ID,
+		UUID:          strings.ToUpper(uuid),
+		Query:         query,
+		Interval:      interval,
+		Creator:       creator,
+		ExpiresAt:     expires,
+	}
+	// Only one active override per query and node
+	if err := environment.DB.Where("uuid = ? AND query = ?", override.UUID, query).Delete(&ScheduleOverride{}).Error; err != nil {
+		return override, fmt.Errorf("Delete %v", err)
+	}
+	if err := environment.DB.Create(&override).Error; err != nil {
+		return override, fmt.Errorf("Create %v", err)
+	}
+	return override, nil
+}
+
+// NodeScheduleOverrides to get the active schedule overrides for a node
+func (environment *Environment) NodeScheduleOverrides(uuid string) ([]ScheduleOverride, error) {
+	return environment.NodeScheduleOverridesCtx(context.Background(), uuid)
+}
+
+// NodeScheduleOverridesCtx to get the active schedule overrides for a node, aborting if ctx is cancelled
+func (environment *Environment) NodeScheduleOverridesCtx(ctx context.Context, uuid string) ([]ScheduleOverride, error) {
+	var overrides []ScheduleOverride
+	if err := environment.read().WithContext(ctx).Where("uuid = ? AND expires_at > ?", strings.ToUpper(uuid), time.Now()).Order("query").Find(&overrides).Error; err != nil {
+		return overrides, err
+	}
+	return overrides, nil
+}
+
+// EnvScheduleOverrides to get the active schedule overrides for all the nodes in an environment
+func (environment *Environment) EnvScheduleOverrides(envid uint) ([]ScheduleOverride, error) {
+	var overrides []ScheduleOverride
+	if err := environment.read().Where("environment_id = ? AND expires_at > ?", envid, time.Now()).Order("expires_at").Find(&overrides).Error; err != nil {
+		return overrides, err
+	}
+	return overrides, nil
+}
+
+// GetScheduleOverride to get one schedule override by id
+func (environment *Environment) GetScheduleOverride(id uint) (ScheduleOverride, error) {
+	var override ScheduleOverride
+	if err := environment.DB.First(&override, id).Error; err != nil {
+		return override, err
+	}
+	return override, nil
+}
+
+// DeleteScheduleOverride to remove a schedule override before it expires
+func (environment *Environment) DeleteScheduleOverride(id uint) error {
+	if err := environment.DB.Unscoped().Delete(&ScheduleOverride{}, id).Error; err != nil {
+		return fmt.Errorf("Delete %v", err)
+	}
+	return nil
+}
+
+// CleanScheduleOverrides to remove all the expired schedule overrides
+func (environment *Environment) CleanScheduleOverrides() error {
+	if err := environment.DB.Unscoped().Where("expires_at <= ?", time.Now()).Delete(&ScheduleOverride{}).Error; err != nil {
+		return fmt.Errorf("Delete %v", err)
+	}
+	return nil
+}
+
+// ApplyScheduleOverrides to change the intervals of the overridden queries in a serialized configuration.
+// Anything that is not a schedule or pack query interval is kept as it is.
+func ApplyScheduleOverrides(configuration []byte, overrides []ScheduleOverride) ([]byte, error) {
+	if len(overrides) == 0 {
+		return configuration, nil
+	}
+	var conf map[string]json.RawMessage
+	if err := json.Unmarshal(configuration, &conf); err != nil {
+		return configuration, fmt.Errorf("Unmarshal %v", err)
+	}
+	var schedule map[string]map[string]interface{}
+	if raw, ok := conf["schedule"]; ok {
+		if err := json.Unmarshal(raw, &schedule); err != nil {
+			return configuration, fmt.Errorf("Unmarshal schedule %v", err)
+		}
+	}
+	var packs map[string]interface{}
+	if raw, ok := conf["packs"]; ok {
+		if err := json.Unmarshal(raw, &packs); err != nil {
+			return configuration, fmt.Errorf("Unmarshal packs %v", err)
+		}
+	}
+	for _, o := range overrides {
+		if q, ok := schedule[o.Query]; ok {
+			q["interval"] = o.Interval
+			continue
+		}
+		// Packs in the order of their names, only the first query with the name is overridden
+		for _, p := range sortedKeys(packs) {
+			// Local packs are only a path, nothing to override there
+			entry, ok := packs[p].(map[string]interface{})
+			if !ok || !strings.HasPrefix(o.Query, PackQueryPrefix+p+"_") {
+				continue
+			}
+			queries, ok := entry["queries"].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if q, ok := queries[strings.TrimPrefix(o.Query, PackQueryPrefix+p+"_")].(map[string]interface{}); ok {
+				q["interval"] = o.Interval
+				break
+			}
+		}
+	}
+	var err error
+	if schedule != nil {
+		if conf["schedule"], err = json.Marshal(schedule); err != nil {
+			return configuration, fmt.Errorf("Marshal schedule %v", err)
+		}
+	}
+	if packs != nil {
+		if conf["packs"], err = json.Marshal(packs); err != nil {
+			return configuration, fmt.Errorf("Marshal packs %v", err)
+		}
+	}
+	res, err := json.MarshalIndent(conf, "", "  ")
+	if err != nil {
+		return configuration, fmt.Errorf("Marshal %v", err)
+	}
+	return res, nil
+}
@@ -0,0 +1,48 @@
+package environments
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testOverrideConf = `{
+  "options": {"host_identifier": "uuid"},
+  "schedule": {
+    "uptime": {"query": "SELECT * FROM uptime;", "interval": 3600},
+    "users": {"query": "SELECT * FROM users;", "interval": 3600}
+  },
+  "packs": {
+    "local": "/etc/osquery/packs/local.conf",
+    "it": {"queries": {"usb": {"query": "SELECT * FROM usb_devices;", "interval": 7200}}}
+  },
+  "file_paths": {"etc": ["/etc/%%"]}
+}`
+
+func TestApplyScheduleOverrides(t *testing.T) {
+	overrides := []ScheduleOverride{
+		{Query: "uptime", Interval: 60},
+		{Query: PackQueryName("it", "usb"), Interval: 120},
+	}
+	res, err := ApplyScheduleOverrides([]byte(testOverrideConf), overrides)
+	assert.NoError(t, err)
+	var conf struct {
+		Schedule  ScheduleConf           `json:"schedule"`
+		Packs     map[string]interface{} `json:"packs"`
+		FilePaths map[string][]string    `json:"file_paths"`
+	}
+	assert.NoError(t, json.Unmarshal(res, &conf))
+	assert.Equal(t, json.Number("60"), conf.Schedule["uptime"].Interval)
+	assert.Equal(t, json.Number("3600"), conf.Schedule["users"].Interval)
+	assert.Equal(t, "/etc/osquery/packs/local.conf", conf.Packs["local"])
+	pack := conf.Packs["it"].(map[string]interface{})["queries"].(map[string]interface{})["usb"].(map[string]interface{})
+	assert.Equal(t, float64(120), pack["interval"])
+	assert.Equal(t, []string{"/etc/%%"}, conf.FilePaths["etc"])
+}
+
+func TestApplyScheduleOverridesEmpty(t *testing.T) {
+	res, err := ApplyScheduleOverrides([]byte(testOverrideConf), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, testOverrideConf, string(res))
+}
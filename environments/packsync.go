@@ -0,0 +1,896 @@
+package environments
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jmpsec/osctrl/backend"
+	"gorm.io/gorm"
+)
+
+// Authentication of pack sources
+const (
+	PackAuthNone  string = "none"
+	PackAuthToken string = "token"
+	PackAuthSSH   string = "ssh"
+)
+
+// Resolutions of conflicts between packs of a source and packs edited manually
+const (
+	PackRepoWins   string = "repo"
+	PackManualWins string = "manual"
+)
+
+// Outcomes of the sync of a pack source
+const (
+	PackSyncOK        string = "ok"
+	PackSyncConflicts string = "conflicts"
+	PackSyncFailed    string = "failed"
+)
+
+const (
+	// DefaultPackSyncInterval is how often pack sources are checked to be synced
+	DefaultPackSyncInterval = time.Minute
+	// DefaultPackSyncTimeout is how long fetching and syncing one pack source can take
+	DefaultPackSyncTimeout = 2 * time.Minute
+	// DefaultPackSyncEvery is the default number of minutes between syncs of a pack source
+	DefaultPackSyncEvery int = 60
+	// MinPackSyncEvery is the minimum number of minutes between syncs of a pack source
+	MinPackSyncEvery int = 5
+	// DefaultPackGlob is the default path glob of the pack files in a repository
+	DefaultPackGlob string = "packs/*.json"
+	// PackSyncActor is the actor of the activity of syncs that were not requested by a user
+	PackSyncActor string = "pack-sync"
+	// PackCredentialEnvPrefix is the prefix of the variables that can hold credentials of pack sources
+	PackCredentialEnvPrefix string = "OSCTRL_PACK_"
+	// DefaultPackCredentialsDir is the default directory of the files that can hold credentials of pack sources
+	DefaultPackCredentialsDir string = "config/packs"
+	// maxPackFileSize is the maximum size of a pack file in a repository
+	maxPackFileSize int64 = 1024 * 1024
+	// maxPackFiles is the maximum number of pack files synced from a repository
+	maxPackFiles int = 500
+	// maxPackSyncFailures is how many validation failures are kept in the summary of a sync
+	maxPackSyncFailures int = 20
+	// maxGitOutput is the maximum output of git kept in errors
+	maxGitOutput int = 1024
+)
+
+// ErrNoPackSource is returned when the environment has no pack source
+var ErrNoPackSource = errors.New("environment has no pack source")
+
+var (
+	// Repositories over SSH with the short syntax, like git@github.com:org/repo.git
+	packSCPRegex = regexp.MustCompile(`^[A-Za-z0-9._-]+@[A-Za-z0-9.-]+:[A-Za-z0-9._~/-]+$`)
+	// Valid branch names of pack sources
+	packBranchRegex = regexp.MustCompile(`^[A-Za-z0-9._/-]+$`)
+	// Valid names of the variables with credentials of pack sources, only with the prefix for credentials
+	packEnvRegex = regexp.MustCompile(`^` + PackCredentialEnvPrefix + `[A-Za-z0-9_]+$`)
+	// Valid names of the files with credentials of pack sources, without directories
+	packFileRegex = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._-]*$`)
+)
+
+// PackSource to keep the Git repository that the packs of an environment are synced from.
+// Credential is a reference to a token or SSH key as env:OSCTRL_PACK_NAME or file:name, for a file in the credentials
+// directory of the service, the secret itself is never stored.
+type PackSource struct {
+	gorm.Model
+	EnvironmentID uint   `gorm:"uniqueIndex" json:"environment_id"`
+	URL           string `json:"url"`
+	Branch        string `json:"branch"`
+	PathGlob      string `json:"path_glob"`
+	AuthKind      string `json:"auth_kind"`
+	Credential    string `json:"credential"`
+	// Minutes between syncs
+	Interval      int       `json:"interval"`
+	Enabled       bool      `json:"enabled"`
+	SyncRequested bool      `json:"sync_requested"`
+	RequestedBy   string    `json:"requested_by"`
+	CreatedBy     string    `json:"created_by"`
+	LastAttempt   time.Time `json:"last_attempt"`
+	LastSync      time.Time `json:"last_sync"`
+	LastCommit    string    `json:"last_commit"`
+	LastStatus    string    `json:"last_status"`
+	LastSummary   string    `json:"last_summary"`
+	LastError     string    `json:"last_error"`
+}
+
+// PackSyncPack to keep a pack of an environment synced from its source. Hash is the content last written by the sync,
+// so manual edits are detected. Conflicts keep the content of the repository until they are resolved.
+type PackSyncPack struct {
+	gorm.Model
+	EnvironmentID uint   `gorm:"index" json:"environment_id"`
+	Name          string `json:"name"`
+	Path          string `json:"path"`
+	Commit        string `json:"commit"`
+	Hash          string `json:"hash"`
+	Resolution    string `json:"resolution"`
+	Conflict      bool   `json:"conflict"`
+	RepoHash      string `json:"repo_hash"`
+	RepoContent   string `json:"repo_content"`
+}
+
+// RepoPack to hold a valid pack read from a file of a repository
+type RepoPack struct {
+	Name    string
+	Path    string
+	Content json.RawMessage
+	Hash    string
+}
+
+// PackSyncResult to hold the outcome of syncing the packs of an environment from its source
+type PackSyncResult struct {
+	Commit    string   `json:"commit"`
+	Status    string   `json:"status"`
+	Added     []string `json:"added"`
+	Updated   []string `json:"updated"`
+	Removed   []string `json:"removed"`
+	Conflicts []string `json:"conflicts"`
+	Failures  []string `json:"failures"`
+	Error     string   `json:"error"`
+}
+
+// PackSyncStatus to hold the pack source of an environment with the packs synced from it and their conflicts
+type PackSyncStatus struct {
+	Source    PackSource     `json:"source"`
+	Packs     []PackSyncPack `json:"packs"`
+	Conflicts int            `json:"conflicts"`
+}
+
+// PackFetcher to fetch the repository of a pack source into a directory that does not exist, returning the commit
+type PackFetcher func(ctx context.Context, source PackSource, dir string) (string, error)
+
+// Changed to check if a sync changed the packs of the environment
+func (r PackSyncResult) Changed() bool {
+	return len(r.Added)+len(r.Updated)+len(r.Removed) > 0
+}
+
+// Summary to describe the outcome of a sync in one line, as it is recorded in the activity
+func (r PackSyncResult) Summary(source PackSource) string {
+	commit := r.Commit
+	if len(commit) > 12 {
+		commit = commit[:12]
+	}
+	summary := "packs synced from " + source.URL + "@" + source.Branch
+	if commit != "" {
+		summary += " at " + commit
+	}
+	if r.Status == PackSyncFailed {
+		summary = "pack sync from " + source.URL + "@" + source.Branch + " failed"
+		if commit != "" {
+			summary += " at " + commit
+		}
+		if r.Error != "" {
+			summary += ": " + r.Error
+		}
+		failures := r.Failures
+		if len(failures) > maxPackSyncFailures {
+			failures = append(failures[:maxPackSyncFailures:maxPackSyncFailures], fmt.Sprintf("and %d more", len(r.Failures)-maxPackSyncFailures))
+		}
+		if len(failures) > 0 {
+			summary += ": " + strings.Join(failures, "; ")
+		}
+		return summary
+	}
+	var parts []string
+	for _, p := range []struct {
+		label string
+		names []string
+	}{
+		{"added", r.Added},
+		{"updated", r.Updated},
+		{"removed", r.Removed},
+		{"conflicts", r.Conflicts},
+	} {
+		if len(p.names) > 0 {
+			parts = append(parts, p.label+" "+strings.Join(p.names, ", "))
+		}
+	}
+	if len(parts) == 0 {
+		return summary + ", no changes"
+	}
+	return summary + ": " + strings.Join(parts, "; ")
+}
+
+// Due to check if a pack source needs to be synced, because it was requested or its interval passed
+func (s PackSource) Due(now time.Time) bool {
+	if !s.Enabled {
+		return false
+	}
+	interval := s.Interval
+	if interval <= 0 {
+		interval = DefaultPackSyncEvery
+	}
+	return s.SyncRequested || !s.LastAttempt.Add(time.Duration(interval)*time.Minute).After(now)
+}
+
+// String to describe a pack source, as it is recorded in the activity
+func (s PackSource) String() string {
+	if s.ID == 0 && s.URL == "" {
+		return ""
+	}
+	return fmt.Sprintf("url: %s\nbranch: %s\npath_glob: %s\nauth: %s %s\ninterval: %d\nenabled: %t", s.URL, s.Branch, s.PathGlob, s.AuthKind, s.Credential, s.Interval, s.Enabled)
+}
+
+// Helper to check if the URL of a pack source uses SSH
+func packSSHURL(u string) bool {
+	return strings.HasPrefix(u, "ssh://") || packSCPRegex.MatchString(u)
+}
+
+// ValidatePackSource to check the repository, branch, glob, credentials and interval of a pack source.
+// Empty branch, glob, authentication and interval get their defaults.
+func ValidatePackSource(s PackSource) (PackSource, error) {
+	s.URL = strings.TrimSpace(s.URL)
+	if strings.HasPrefix(s.URL, "https://") || strings.HasPrefix(s.URL, "ssh://") {
+		u, err := url.Parse(s.URL)
+		if err != nil || u.Host == "" {
+			return s, fmt.Errorf("invalid repository URL %s", s.URL)
+		}
+		if _, hasPassword := u.User.Password(); hasPassword {
+			return s, fmt.Errorf("credentials can not be in the repository URL")
+		}
+	} else if !packSCPRegex.MatchString(s.URL) {
+		return s, fmt.Errorf("invalid repository URL %s, it must use https or ssh", s.URL)
+	}
+	if s.Branch == "" {
+		s.Branch = "main"
+	}
+	if !packBranchRegex.MatchString(s.Branch) || strings.HasPrefix(s.Branch, "-") || strings.Contains(s.Branch, "..") {
+		return s, fmt.Errorf("invalid branch %s", s.Branch)
+	}
+	if s.PathGlob == "" {
+		s.PathGlob = DefaultPackGlob
+	}
+	if _, err := path.Match(s.PathGlob, ""); err != nil || strings.HasPrefix(s.PathGlob, "/") || strings.Contains(s.PathGlob, "..") {
+		return s, fmt.Errorf("invalid path glob %s", s.PathGlob)
+	}
+	if s.AuthKind == "" {
+		s.AuthKind = PackAuthNone
+	}
+	switch s.AuthKind {
+	case PackAuthNone:
+		s.Credential = ""
+	case PackAuthToken:
+		if !strings.HasPrefix(s.URL, "https://") {
+			return s, fmt.Errorf("token authentication needs an https repository URL")
+		}
+	case PackAuthSSH:
+		if !packSSHURL(s.URL) {
+			return s, fmt.Errorf("ssh authentication needs an ssh repository URL")
+		}
+	default:
+		return s, fmt.Errorf("invalid authentication %s", s.AuthKind)
+	}
+	if s.AuthKind != PackAuthNone {
+		if _, _, err := parsePackCredential(s.Credential); err != nil {
+			return s, err
+		}
+	}
+	if s.Interval == 0 {
+		s.Interval = DefaultPackSyncEvery
+	}
+	if s.Interval < MinPackSyncEvery {
+		return s, fmt.Errorf("interval must be at least %d minutes", MinPackSyncEvery)
+	}
+	return s, nil
+}
+
+// Helper to split a reference to a credential in its kind, env or file, and its variable or file name. Credentials
+// are limited to variables with the prefix for credentials and to files in the credentials directory, so a pack
+// source can not send other secrets of the service to its repository
+func parsePackCredential(ref string) (string, string, error) {
+	parts := strings.SplitN(strings.TrimSpace(ref), ":", 2)
+	if len(parts) == 2 {
+		kind, value := parts[0], parts[1]
+		switch {
+		case kind == "env" && packEnvRegex.MatchString(value):
+			return kind, value, nil
+		case kind == "file" && packFileRegex.MatchString(value):
+			return kind, value, nil
+		}
+	}
+	return "", "", fmt.Errorf("invalid credential %s, it must be env:%sNAME or file:name in the credentials directory", ref, PackCredentialEnvPrefix)
+}
+
+// ResolvePackCredential to read the token or SSH key referenced by a pack source from the environment or a file of
+// the credentials directory
+func ResolvePackCredential(ref, dir string) (string, error) {
+	kind, value, err := parsePackCredential(ref)
+	if err != nil {
+		return "", err
+	}
+	var secret string
+	if kind == "env" {
+		secret = os.Getenv(value)
+	} else {
+		raw, err := os.ReadFile(filepath.Join(dir, value))
+		if err != nil {
+			return "", fmt.Errorf("ReadFile %v", err)
+		}
+		secret = string(raw)
+	}
+	if strings.TrimSpace(secret) == "" {
+		return "", fmt.Errorf("credential %s is empty", ref)
+	}
+	return secret, nil
+}
+
+// GitFetcher to get the fetcher that clones the branch of a pack source with git, with the credentials of sources
+// from the environment and from files in the credentials directory
+func GitFetcher(credentialsDir string) PackFetcher {
+	return func(ctx context.Context, source PackSource, dir string) (string, error) {
+		return gitFetch(ctx, source, dir, credentialsDir)
+	}
+}
+
+// Helper to clone the branch of a pack source with git, without history, and get the commit of its head
+func gitFetch(ctx context.Context, source PackSource, dir, credentialsDir string) (string, error) {
+	env := append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if source.AuthKind != PackAuthNone {
+		secret, err := ResolvePackCredential(source.Credential, credentialsDir)
+		if err != nil {
+			return "", err
+		}
+		switch source.AuthKind {
+		case PackAuthToken:
+			// The token goes in a header through the environment, so it is not in the arguments or in the URL
+			basic := base64.StdEncoding.EncodeToString([]byte("x-access-token:" + strings.TrimSpace(secret)))
+			env = append(env, "GIT_CONFIG_COUNT=1", "GIT_CONFIG_KEY_0=http.extraHeader", "GIT_CONFIG_VALUE_0=Authorization: Basic "+basic)
+		case PackAuthSSH:
+			key := filepath.Join(credentialsDir, strings.TrimPrefix(source.Credential, "file:"))
+			if strings.HasPrefix(source.Credential, "env:") {
+				f, err := os.CreateTemp("", "osctrl-pack-key-")
+				if err != nil {
+					return "", fmt.Errorf("CreateTemp %v", err)
+				}
+				defer os.Remove(f.Name())
+				_, err = f.WriteString(strings.TrimSpace(secret) + "\n")
+				if cerr := f.Close(); err == nil {
+					err = cerr
+				}
+				if err != nil {
+					return "", fmt.Errorf("WriteString %v", err)
+				}
+				key = f.Name()
+			}
+			env = append(env, fmt.Sprintf("GIT_SSH_COMMAND=ssh -i '%s' -o IdentitiesOnly=yes -o BatchMode=yes", key))
+		}
+	}
+	run := func(args ...string) (string, error) {
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Env = env
+		out, err := cmd.CombinedOutput()
+		output := strings.TrimSpace(string(out))
+		if err != nil {
+			if len(output) > maxGitOutput {
+				output = output[:maxGitOutput]
+			}
+			return "", fmt.Errorf("git %s %v: %s", args[0], err, output)
+		}
+		return output, nil
+	}
+	if _, err := run("clone", "--quiet", "--depth", "1", "--single-branch", "--branch", source.Branch, "--", source.URL, dir); err != nil {
+		return "", err
+	}
+	return run("-C", dir, "rev-parse", "HEAD")
+}
+
+// Helper to get the hash of a pack from its JSON, ignoring the format, or from its raw value if it is not valid JSON
+func packHash(raw json.RawMessage) string {
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err == nil {
+		if canonical, err := json.Marshal(v); err == nil {
+			raw = canonical
+		}
+	}
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// ValidatePackFile to check the content of a pack file like packs added to the configuration are checked,
+// returning the pack without format and the failures found
+func ValidatePackFile(name string, content []byte) (json.RawMessage, []string) {
+	var entry PackEntry
+	if err := json.Unmarshal(content, &entry); err != nil {
+		return nil, []string{fmt.Sprintf("%s: invalid pack %v", name, err)}
+	}
+	if len(entry.Queries) == 0 {
+		return nil, []string{name + ": pack has no queries"}
+	}
+	var v interface{}
+	if err := json.Unmarshal(content, &v); err != nil {
+		return nil, []string{fmt.Sprintf("%s: invalid pack %v", name, err)}
+	}
+	canonical, err := json.Marshal(v)
+	if err != nil {
+		return nil, []string{fmt.Sprintf("%s: invalid pack %v", name, err)}
+	}
+	conf, err := json.Marshal(map[string]map[string]json.RawMessage{"packs": {name: canonical}})
+	if err != nil {
+		return nil, []string{fmt.Sprintf("%s: invalid pack %v", name, err)}
+	}
+	var failures []string
+	for _, i := range VerifyConfiguration(conf, "") {
+		if i.Severity == VerifyError {
+			failures = append(failures, i.String())
+		}
+	}
+	if len(failures) > 0 {
+		return nil, failures
+	}
+	return canonical, nil
+}
+
+// ReadRepoPacks to read and validate the pack files of a repository that match a glob. Packs are named after their
+// files without extension. Any failure means the repository can not be synced, so nothing is changed.
+func ReadRepoPacks(dir, glob string) (map[string]RepoPack, []string, error) {
+	packs := make(map[string]RepoPack)
+	var failures []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		// Links are not followed, so files out of the repository are never read
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if matched, _ := path.Match(glob, rel); !matched {
+			return nil
+		}
+		name := strings.TrimSuffix(path.Base(rel), path.Ext(rel))
+		if name == "" {
+			failures = append(failures, rel+": pack name can not be empty")
+			return nil
+		}
+		if existing, ok := packs[name]; ok {
+			failures = append(failures, fmt.Sprintf("%s: pack %s is also in %s", rel, name, existing.Path))
+			return nil
+		}
+		if len(packs) >= maxPackFiles {
+			return fmt.Errorf("more than %d pack files", maxPackFiles)
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.Size() > maxPackFileSize {
+			failures = append(failures, fmt.Sprintf("%s: file is larger than %d bytes", rel, maxPackFileSize))
+			return nil
+		}
+		content, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		pack, issues := ValidatePackFile(name, content)
+		if len(issues) > 0 {
+			for _, i := range issues {
+				failures = append(failures, rel+": "+i)
+			}
+			return nil
+		}
+		packs[name] = RepoPack{Name: name, Path: rel, Content: pack, Hash: packHash(pack)}
+		return nil
+	})
+	if err != nil {
+		return packs, failures, fmt.Errorf("WalkDir %v", err)
+	}
+	return packs, failures, nil
+}
+
+// PlanPackSync to calculate the packs of an environment after a sync and the state of the synced packs.
+// Packs that were edited manually since they were synced are conflicts and they are kept as they are, unless
+// the repository wins for them. Packs where the manual version wins are never changed, and packs not synced
+// from the repository are never removed.
+func PlanPackSync(current map[string]json.RawMessage, repo map[string]RepoPack, tracked map[string]PackSyncPack, commit string) (map[string]json.RawMessage, map[string]PackSyncPack, PackSyncResult) {
+	next := make(map[string]json.RawMessage, len(current))
+	for name, raw := range current {
+		next[name] = raw
+	}
+	state := make(map[string]PackSyncPack)
+	result := PackSyncResult{Commit: commit, Status: PackSyncOK}
+	for _, name := range sortedKeys(repo) {
+		rp := repo[name]
+		t, isTracked := tracked[name]
+		t.Name = name
+		t.Path = rp.Path
+		t.RepoHash = rp.Hash
+		t.Conflict = false
+		t.RepoContent = ""
+		raw, inEnv := current[name]
+		switch {
+		case t.Resolution == PackManualWins:
+			// The pack is managed manually
+		case !inEnv:
+			next[name] = rp.Content
+			t.Hash = rp.Hash
+			t.Commit = commit
+			result.Added = append(result.Added, name)
+		case packHash(raw) == rp.Hash:
+			// Same content, it only needs to be tracked
+			t.Hash = rp.Hash
+			if t.Commit == "" {
+				t.Commit = commit
+			}
+		case (isTracked && t.Hash != "" && packHash(raw) == t.Hash) || t.Resolution == PackRepoWins:
+			next[name] = rp.Content
+			t.Hash = rp.Hash
+			t.Commit = commit
+			result.Updated = append(result.Updated, name)
+		default:
+			t.Conflict = true
+			t.RepoContent = string(rp.Content)
+			result.Conflicts = append(result.Conflicts, name)
+		}
+		state[name] = t
+	}
+	for _, name := range sortedKeys(tracked) {
+		if _, ok := repo[name]; ok {
+			continue
+		}
+		t := tracked[name]
+		raw, inEnv := current[name]
+		switch {
+		case !inEnv, t.Resolution == PackManualWins:
+			// Packs removed from the repository that are gone or managed manually are not tracked anymore
+		case (t.Hash != "" && packHash(raw) == t.Hash) || t.Resolution == PackRepoWins:
+			delete(next, name)
+			result.Removed = append(result.Removed, name)
+		default:
+			t.Conflict = true
+			t.RepoHash = ""
+			t.RepoContent = ""
+			state[name] = t
+			result.Conflicts = append(result.Conflicts, name)
+		}
+	}
+	if len(result.Conflicts) > 0 {
+		result.Status = PackSyncConflicts
+	}
+	return next, state, result
+}
+
+// GetPackSource to get the pack source of an environment
+func (environment *Environment) GetPackSource(envid uint) (PackSource, error) {
+	var sources []PackSource
+	if err := environment.DB.Where("environment_id = ?", envid).Limit(1).Find(&sources).Error; err != nil {
+		return PackSource{}, fmt.Errorf("Find %v", err)
+	}
+	if len(sources) == 0 {
+		return PackSource{}, ErrNoPackSource
+	}
+	return sources[0], nil
+}
+
+// SetPackSource to create or update the pack source of an environment, keeping the outcome of its last sync.
+// Changes to the source are synced right away.
+func (environment *Environment) SetPackSource(source PackSource) (PackSource, error) {
+	source, err := ValidatePackSource(source)
+	if err != nil {
+		return source, err
+	}
+	current, err := environment.GetPackSource(source.EnvironmentID)
+	if errors.Is(err, ErrNoPackSource) {
+		source.SyncRequested = source.Enabled
+		source.RequestedBy = source.CreatedBy
+		if err := environment.DB.Create(&source).Error; err != nil {
+			return source, fmt.Errorf("Create %v", err)
+		}
+		return source, nil
+	}
+	if err != nil {
+		return source, err
+	}
+	updates := map[string]interface{}{
+		"url":            source.URL,
+		"branch":         source.Branch,
+		"path_glob":      source.PathGlob,
+		"auth_kind":      source.AuthKind,
+		"credential":     source.Credential,
+		"interval":       source.Interval,
+		"enabled":        source.Enabled,
+		"sync_requested": source.Enabled,
+		"requested_by":   source.CreatedBy,
+	}
+	if err := environment.DB.Model(&current).Updates(updates).Error; err != nil {
+		return current, fmt.Errorf("Updates %v", err)
+	}
+	return environment.GetPackSource(source.EnvironmentID)
+}
+
+// DeletePackSource to remove the pack source of an environment and the state of its packs, the packs are kept
+func (environment *Environment) DeletePackSource(envid uint) error {
+	return environment.DB.Transaction(func(tx *gorm.DB) error {
+		res := tx.Unscoped().Where("environment_id = ?", envid).Delete(&PackSource{})
+		if res.Error != nil {
+			return fmt.Errorf("Delete %v", res.Error)
+		}
+		if res.RowsAffected == 0 {
+			return ErrNoPackSource
+		}
+		if err := tx.Unscoped().Where("environment_id = ?", envid).Delete(&PackSyncPack{}).Error; err != nil {
+			return fmt.Errorf("Delete %v", err)
+		}
+		return nil
+	})
+}
+
+// RequestPackSync to sync the pack source of an environment at the next check, without waiting for its interval
+func (environment *Environment) RequestPackSync(envid uint, actor string) error {
+	res := environment.DB.Model(&PackSource{}).Where("environment_id = ? AND enabled = ?", envid, true).Updates(map[string]interface{}{
+		"sync_requested": true,
+		"requested_by":   actor,
+	})
+	if res.Error != nil {
+		return fmt.Errorf("Updates %v", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return ErrNoPackSource
+	}
+	return nil
+}
+
+// SyncedPacks to get the packs of an environment synced from its source, with their conflicts
+func (environment *Environment) SyncedPacks(envid uint) ([]PackSyncPack, error) {
+	var packs []PackSyncPack
+	if err := environment.read().Where("environment_id = ?", envid).Order("name").Find(&packs).Error; err != nil {
+		return packs, fmt.Errorf("Find %v", err)
+	}
+	return packs, nil
+}
+
+// PackSyncStatus to get the pack source of an environment with its synced packs
+func (environment *Environment) PackSyncStatus(envid uint) (PackSyncStatus, error) {
+	var status PackSyncStatus
+	source, err := environment.GetPackSource(envid)
+	if err != nil {
+		return status, err
+	}
+	status.Source = source
+	if status.Packs, err = environment.SyncedPacks(envid); err != nil {
+		return status, err
+	}
+	for _, p := range status.Packs {
+		if p.Conflict {
+			status.Conflicts++
+		}
+	}
+	return status, nil
+}
+
+// ResolvePackConflict to choose if the repository or the manual version wins for a pack, empty to ask again.
+// The source is synced again to apply the choice.
+func (environment *Environment) ResolvePackConflict(envid uint, name, resolution, actor string) error {
+	if resolution != PackRepoWins && resolution != PackManualWins && resolution != "" {
+		return fmt.Errorf("invalid resolution %s", resolution)
+	}
+	res := environment.DB.Model(&PackSyncPack{}).Where("environment_id = ? AND name = ?", envid, name).Update("resolution", resolution)
+	if res.Error != nil {
+		return fmt.Errorf("Update %v", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("pack %s is not synced", name)
+	}
+	return environment.RequestPackSync(envid, actor)
+}
+
+// DuePackSources to get the enabled pack sources of all environments that need to be synced
+func (environment *Environment) DuePackSources(now time.Time) ([]PackSource, error) {
+	var sources []PackSource
+	if err := environment.DB.Where("enabled = ?", true).Order("id").Find(&sources).Error; err != nil {
+		return nil, fmt.Errorf("Find %v", err)
+	}
+	var due []PackSource
+	for _, s := range sources {
+		if s.Due(now) {
+			due = append(due, s)
+		}
+	}
+	return due, nil
+}
+
+// Helper to save the outcome of a sync in its source and in the activity, only when it is different from the last
+// one or the sync was requested, so periodic syncs without changes do not fill the activity
+func (environment *Environment) finishPackSync(tx *gorm.DB, source PackSource, result PackSyncResult, actor string, now time.Time, record bool) error {
+	summary := result.Summary(source)
+	updates := map[string]interface{}{
+		"last_status":  result.Status,
+		"last_summary": summary,
+		"last_error":   result.Error,
+	}
+	if result.Status != PackSyncFailed {
+		updates["last_sync"] = now
+		updates["last_commit"] = result.Commit
+		updates["last_error"] = ""
+	}
+	if err := tx.Model(&PackSource{}).Where("id = ?", source.ID).Updates(updates).Error; err != nil {
+		return fmt.Errorf("Updates %v", err)
+	}
+	if !record || (!source.SyncRequested && summary == source.LastSummary) {
+		return nil
+	}
+	return (&Environment{DB: tx}).RecordActivity(source.EnvironmentID, ActivityPackSync, actor, summary, "", "")
+}
+
+// SyncPacks to fetch the repository of a pack source and update the packs of its environment to match it, as a new
+// version of the configuration. A repository that can not be fetched or has invalid packs leaves everything as it is.
+// The environment before the sync is returned with the outcome.
+func (environment *Environment) SyncPacks(ctx context.Context, source PackSource, fetch PackFetcher, now time.Time) (TLSEnvironment, PackSyncResult, error) {
+	var env TLSEnvironment
+	result := PackSyncResult{Status: PackSyncFailed}
+	actor := PackSyncActor
+	if source.SyncRequested && source.RequestedBy != "" {
+		actor = source.RequestedBy
+	}
+	// Claim the sync, so a requested sync is only done once
+	err := environment.DB.Model(&PackSource{}).Where("id = ?", source.ID).Updates(map[string]interface{}{
+		"last_attempt":   now,
+		"sync_requested": false,
+	}).Error
+	if err != nil {
+		return env, result, fmt.Errorf("Updates %v", err)
+	}
+	fail := func(err error) (TLSEnvironment, PackSyncResult, error) {
+		result.Status = PackSyncFailed
+		result.Error = err.Error()
+		if ferr := environment.finishPackSync(environment.DB, source, result, actor, now, true); ferr != nil {
+			log.Printf("error saving pack sync of %d %v", source.EnvironmentID, ferr)
+		}
+		return env, result, err
+	}
+	work, err := os.MkdirTemp("", "osctrl-packs-")
+	if err != nil {
+		return fail(fmt.Errorf("MkdirTemp %v", err))
+	}
+	defer os.RemoveAll(work)
+	dir := filepath.Join(work, "repo")
+	if result.Commit, err = fetch(ctx, source, dir); err != nil {
+		return fail(err)
+	}
+	repo, failures, err := ReadRepoPacks(dir, source.PathGlob)
+	if err != nil {
+		return fail(err)
+	}
+	if len(failures) > 0 {
+		result.Failures = failures
+		return fail(fmt.Errorf("%d invalid pack files", len(failures)))
+	}
+	if err := environment.DB.Where("id = ?", source.EnvironmentID).First(&env).Error; err != nil {
+		return fail(fmt.Errorf("First %v", err))
+	}
+	current := make(map[string]json.RawMessage)
+	if strings.TrimSpace(env.Packs) != "" {
+		if err := json.Unmarshal([]byte(env.Packs), &current); err != nil {
+			return fail(fmt.Errorf("packs of %s %v", env.Name, err))
+		}
+	}
+	synced, err := environment.SyncedPacks(env.ID)
+	if err != nil {
+		return fail(err)
+	}
+	tracked := make(map[string]PackSyncPack)
+	for _, p := range synced {
+		tracked[p.Name] = p
+	}
+	next, state, planned := PlanPackSync(current, repo, tracked, result.Commit)
+	planned.Failures = result.Failures
+	result = planned
+	err = environment.DB.Transaction(func(tx *gorm.DB) error {
+		txEnv := &Environment{DB: tx}
+		if result.Changed() {
+			packs, err := environment.GenSerializedConf(next, true)
+			if err != nil {
+				return fmt.Errorf("GenSerializedConf %v", err)
+			}
+			proposed, err := environment.ProposedServed(env, ActivityPacks, packs)
+			if err != nil {
+				return err
+			}
+			// Packs changed while the repository was fetched are synced at the next check
+			res := tx.Model(&TLSEnvironment{}).Where("id = ? AND packs = ?", env.ID, env.Packs).Updates(map[string]interface{}{
+				"packs":         proposed.Packs,
+				"configuration": proposed.Configuration,
+			})
+			if res.Error != nil {
+				return fmt.Errorf("Updates %v", res.Error)
+			}
+			if res.RowsAffected == 0 {
+				return fmt.Errorf("packs of %s changed during the sync", env.Name)
+			}
+			if err := txEnv.recordChange(env, ActivityPacks, actor, result.Summary(source), ""); err != nil {
+				return err
+			}
+		}
+		if err := tx.Unscoped().Where("environment_id = ?", env.ID).Delete(&PackSyncPack{}).Error; err != nil {
+			return fmt.Errorf("Delete %v", err)
+		}
+		for _, name := range sortedKeys(state) {
+			p := state[name]
+			p.ID = 0
+			p.EnvironmentID = env.ID
+			if err := tx.Create(&p).Error; err != nil {
+				return fmt.Errorf("Create %v", err)
+			}
+		}
+		// Changes are already in the activity with the new version
+		return environment.finishPackSync(tx, source, result, actor, now, !result.Changed())
+	})
+	if err != nil {
+		return fail(err)
+	}
+	return env, result, nil
+}
+
+// RunPackSync to sync all the pack sources that are due. Only the instance holding the leader lock does it,
+// the rest skip it. The outcome of each sync is passed to onSync with the environment before the sync.
+func (environment *Environment) RunPackSync(ctx context.Context, leader *backend.LeaderLock, now time.Time, fetch PackFetcher, onSync func(TLSEnvironment, PackSyncResult, error)) {
+	if leader != nil {
+		isLeader, err := leader.Acquire()
+		if err != nil {
+			log.Printf("error acquiring leader lock %v", err)
+			return
+		}
+		if !isLeader {
+			return
+		}
+	}
+	due, err := environment.DuePackSources(now)
+	if err != nil {
+		log.Printf("error getting pack sources %v", err)
+		return
+	}
+	for _, s := range due {
+		syncCtx, cancel := context.WithTimeout(ctx, DefaultPackSyncTimeout)
+		env, result, err := environment.SyncPacks(syncCtx, s, fetch, now)
+		cancel()
+		if err != nil {
+			log.Printf("error syncing packs of environment %d %v", s.EnvironmentID, err)
+		}
+		if env.ID != 0 && onSync != nil {
+			onSync(env, result, err)
+		}
+	}
+}
+
+// StartPackSync to sync the packs of environments from their sources periodically
+func (environment *Environment) StartPackSync(ctx context.Context, leader *backend.LeaderLock, interval time.Duration, fetch PackFetcher, onSync func(TLSEnvironment, PackSyncResult, error)) {
+	if interval <= 0 {
+		interval = DefaultPackSyncInterval
+	}
+	if fetch == nil {
+		fetch = GitFetcher(DefaultPackCredentialsDir)
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				environment.RunPackSync(ctx, leader, time.Now(), fetch, onSync)
+			}
+		}
+	}()
+}
@@ -0,0 +1,156 @@
+package environments
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/jmpsec/osctrl/utils"
+)
+
+// Endpoints of osquery served by each environment in its own paths
+const (
+	EndpointEnroll      string = "enroll"
+	EndpointLog         string = "log"
+	EndpointConfig      string = "config"
+	EndpointQueryRead   string = "read"
+	EndpointQueryWrite  string = "write"
+	EndpointCarverInit  string = "init"
+	EndpointCarverBlock string = "block"
+)
+
+// RandomPathLength as length of the random paths generated for environments
+const RandomPathLength int = 12
+
+// Valid paths for the osquery endpoints, a single segment of the URL
+var pathRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Paths used by osctrld in every environment, so they can not be used by the osquery endpoints
+var reservedPaths = []string{DefaultFlagsPath, DefaultCertPath, DefaultVerifyPath, DefaultScriptPath}
+
+// EnvPaths to hold the paths of the osquery endpoints of an environment
+type EnvPaths struct {
+	Enroll      string `json:"enroll"`
+	Log         string `json:"log"`
+	Config      string `json:"config"`
+	QueryRead   string `json:"query_read"`
+	QueryWrite  string `json:"query_write"`
+	CarverInit  string `json:"carver_init"`
+	CarverBlock string `json:"carver_block"`
+}
+
+// DefaultPaths to get the paths of the osquery endpoints used by new environments
+func DefaultPaths() EnvPaths {
+	return EnvPaths{
+		Enroll:      DefaultEnrollPath,
+		Log:         DefaultLogPath,
+		Config:      DefaultConfigPath,
+		QueryRead:   DefaultQueryReadPath,
+		QueryWrite:  DefaultQueryWritePath,
+		CarverInit:  DefaultCarverInitPath,
+		CarverBlock: DefaultCarverBlockPath,
+	}
+}
+
+// RandomPaths to generate random paths for the osquery endpoints, so they can not be guessed
+func RandomPaths() EnvPaths {
+	return EnvPaths{
+		Enroll:      utils.GenRandomString(RandomPathLength),
+		Log:         utils.GenRandomString(RandomPathLength),
+		Config:      utils.GenRandomString(RandomPathLength),
+		QueryRead:   utils.GenRandomString(RandomPathLength),
+		QueryWrite:  utils.GenRandomString(RandomPathLength),
+		CarverInit:  utils.GenRandomString(RandomPathLength),
+		CarverBlock: utils.GenRandomString(RandomPathLength),
+	}
+}
+
+// Paths to get the paths of the osquery endpoints of an environment, empty paths use the defaults
+func (env TLSEnvironment) Paths() EnvPaths {
+	paths := EnvPaths{
+		Enroll:      env.EnrollPath,
+		Log:         env.LogPath,
+		Config:      env.ConfigPath,
+		QueryRead:   env.QueryReadPath,
+		QueryWrite:  env.QueryWritePath,
+		CarverInit:  env.CarverInitPath,
+		CarverBlock: env.CarverBlockPath,
+	}
+	defaults := DefaultPaths()
+	for endpoint, path := range paths.byEndpoint() {
+		if *path == "" {
+			*path = *defaults.byEndpoint()[endpoint]
+		}
+	}
+	return paths
+}
+
+// Helper to get the paths by endpoint, to read and update them
+func (p *EnvPaths) byEndpoint() map[string]*string {
+	return map[string]*string{
+		EndpointEnroll:      &p.Enroll,
+		EndpointLog:         &p.Log,
+		EndpointConfig:      &p.Config,
+		EndpointQueryRead:   &p.QueryRead,
+		EndpointQueryWrite:  &p.QueryWrite,
+		EndpointCarverInit:  &p.CarverInit,
+		EndpointCarverBlock: &p.CarverBlock,
+	}
+}
+
+// Endpoint to get the osquery endpoint served in a path, false if the path is not used by any endpoint
+func (p EnvPaths) Endpoint(path string) (string, bool) {
+	for endpoint, value := range p.byEndpoint() {
+		if *value == path {
+			return endpoint, true
+		}
+	}
+	return "", false
+}
+
+// Validate to check the paths are single segments of the URL, unique and not used by osctrld
+func (p EnvPaths) Validate() error {
+	seen := make(map[string]string)
+	for _, endpoint := range []string{EndpointEnroll, EndpointLog, EndpointConfig, EndpointQueryRead, EndpointQueryWrite, EndpointCarverInit, EndpointCarverBlock} {
+		path := *p.byEndpoint()[endpoint]
+		if !pathRegexp.MatchString(path) {
+			return fmt.Errorf("invalid %s path %q", endpoint, path)
+		}
+		for _, reserved := range reservedPaths {
+			if strings.EqualFold(path, reserved) {
+				return fmt.Errorf("%s path %s is reserved", endpoint, path)
+			}
+		}
+		if other, ok := seen[path]; ok {
+			return fmt.Errorf("%s path %s is already used by %s", endpoint, path, other)
+		}
+		seen[path] = endpoint
+	}
+	return nil
+}
+
+// String to summarize the paths, as recorded in the activity of environments
+func (p EnvPaths) String() string {
+	return "enroll_path: " + p.Enroll + "\nlog_path: " + p.Log + "\nconfig_path: " + p.Config +
+		"\nquery_read_path: " + p.QueryRead + "\nquery_write_path: " + p.QueryWrite +
+		"\ncarver_init_path: " + p.CarverInit + "\ncarver_block_path: " + p.CarverBlock
+}
+
+// UpdatePaths to update the paths of the osquery endpoints of an environment, nodes need new flags to use them
+func (environment *Environment) UpdatePaths(idEnv string, paths EnvPaths) error {
+	if err := paths.Validate(); err != nil {
+		return err
+	}
+	if err := environment.DB.Model(&TLSEnvironment{}).Where("name = ? OR uuid = ?", idEnv, idEnv).Updates(map[string]interface{}{
+		"enroll_path":       paths.Enroll,
+		"log_path":          paths.Log,
+		"config_path":       paths.Config,
+		"query_read_path":   paths.QueryRead,
+		"query_write_path":  paths.QueryWrite,
+		"carver_init_path":  paths.CarverInit,
+		"carver_block_path": paths.CarverBlock,
+	}).Error; err != nil {
+		return fmt.Errorf("UpdatePaths %v", err)
+	}
+	return nil
+}
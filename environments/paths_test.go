@@ -0,0 +1,41 @@
+package environments
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPaths(t *testing.T) {
+	env := TLSEnvironment{EnrollPath: "join", LogPath: "logs"}
+	paths := env.Paths()
+	assert.Equal(t, "join", paths.Enroll)
+	assert.Equal(t, "logs", paths.Log)
+	assert.Equal(t, DefaultConfigPath, paths.Config)
+	assert.Equal(t, DefaultCarverBlockPath, paths.CarverBlock)
+	endpoint, ok := paths.Endpoint("join")
+	assert.True(t, ok)
+	assert.Equal(t, EndpointEnroll, endpoint)
+	endpoint, ok = paths.Endpoint(DefaultQueryWritePath)
+	assert.True(t, ok)
+	assert.Equal(t, EndpointQueryWrite, endpoint)
+	_, ok = paths.Endpoint(DefaultEnrollPath)
+	assert.False(t, ok)
+}
+
+func TestValidatePaths(t *testing.T) {
+	assert.NoError(t, DefaultPaths().Validate())
+	assert.NoError(t, RandomPaths().Validate())
+	paths := DefaultPaths()
+	paths.Log = paths.Config
+	assert.Error(t, paths.Validate())
+	paths = DefaultPaths()
+	paths.Enroll = "en/roll"
+	assert.Error(t, paths.Validate())
+	paths = DefaultPaths()
+	paths.Enroll = ""
+	assert.Error(t, paths.Validate())
+	paths = DefaultPaths()
+	paths.Config = DefaultFlagsPath
+	assert.Error(t, paths.Validate())
+}
@@ -0,0 +1,102 @@
+package environments
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// PlatformPolicyReject to reject enrolls of nodes with platforms not allowed in the environment
+	PlatformPolicyReject string = "reject"
+	// PlatformPolicyQuarantine to enroll nodes with platforms not allowed, but without schedule or queries until approved
+	PlatformPolicyQuarantine string = "quarantine"
+	// DefaultPlatformPolicy as default policy for nodes with platforms not allowed
+	DefaultPlatformPolicy string = PlatformPolicyReject
+)
+
+// Valid names of platforms, as reported by osquery
+var platformRegexp = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// IsPlatformPolicy to check if a policy for platforms not allowed is valid
+func IsPlatformPolicy(policy string) bool {
+	return policy == PlatformPolicyReject || policy == PlatformPolicyQuarantine
+}
+
+// ParsePlatforms to parse a comma separated list of platforms, sorted and without duplicates. Empty allows all.
+func ParsePlatforms(list string) ([]string, error) {
+	seen := make(map[string]bool)
+	var platforms []string
+	for _, p := range strings.Split(list, ",") {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if p == "" || seen[p] {
+			continue
+		}
+		if !platformRegexp.MatchString(p) {
+			return nil, fmt.Errorf("invalid platform %s", p)
+		}
+		seen[p] = true
+		platforms = append(platforms, p)
+	}
+	sort.Strings(platforms)
+	return platforms, nil
+}
+
+// PlatformAllowed to check if a platform is allowed by the comma separated list of an environment.
+// Empty list allows all platforms, linux allows all linux distributions and posix all posix platforms.
+func PlatformAllowed(allowed, platform string) bool {
+	platforms, err := ParsePlatforms(allowed)
+	if err != nil || len(platforms) == 0 {
+		return true
+	}
+	platform = strings.ToLower(platform)
+	for _, p := range platforms {
+		if p == "linux" && IsPlatformLinux(platform) {
+			return true
+		}
+		if IsPlatformQuery(p, platform) {
+			return true
+		}
+	}
+	return false
+}
+
+// FilterPlatforms to get the platforms allowed by the comma separated list of an environment
+func FilterPlatforms(allowed string, platforms []string) []string {
+	var res []string
+	for _, p := range platforms {
+		if PlatformAllowed(allowed, p) {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+// UpdateAllowedPlatforms to update the platforms allowed to enroll in an environment, empty allows all
+func (environment *Environment) UpdateAllowedPlatforms(idEnv, list string) error {
+	platforms, err := ParsePlatforms(list)
+	if err != nil {
+		return err
+	}
+	if err := environment.DB.Model(&TLSEnvironment{}).Where("name = ? OR uuid = ?", idEnv, idEnv).Update("allowed_platforms", strings.Join(platforms, ",")).Error; err != nil {
+		return fmt.Errorf("UpdateAllowedPlatforms %v", err)
+	}
+	return nil
+}
+
+// QuarantineConfig to remove the schedule and packs of a serialized configuration, for quarantined nodes
+func QuarantineConfig(configuration []byte) ([]byte, error) {
+	var conf map[string]json.RawMessage
+	if err := json.Unmarshal(configuration, &conf); err != nil {
+		return configuration, fmt.Errorf("Unmarshal %v", err)
+	}
+	delete(conf, "schedule")
+	delete(conf, "packs")
+	res, err := json.MarshalIndent(conf, "", "  ")
+	if err != nil {
+		return configuration, fmt.Errorf("Marshal %v", err)
+	}
+	return res, nil
+}
@@ -0,0 +1,45 @@
+package environments
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePlatforms(t *testing.T) {
+	platforms, err := ParsePlatforms(" Windows,darwin,, windows ")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"darwin", "windows"}, platforms)
+	platforms, err = ParsePlatforms("")
+	assert.NoError(t, err)
+	assert.Empty(t, platforms)
+	_, err = ParsePlatforms("darwin,win dows")
+	assert.Error(t, err)
+}
+
+func TestPlatformAllowed(t *testing.T) {
+	assert.True(t, PlatformAllowed("", "windows"))
+	assert.True(t, PlatformAllowed("darwin,windows", "windows"))
+	assert.False(t, PlatformAllowed("darwin,windows", "ubuntu"))
+	assert.True(t, PlatformAllowed("linux", "ubuntu"))
+	assert.True(t, PlatformAllowed("linux", "linux"))
+	assert.False(t, PlatformAllowed("linux", "darwin"))
+	assert.True(t, PlatformAllowed("posix", "darwin"))
+	assert.False(t, PlatformAllowed("posix", "windows"))
+	assert.Equal(t, []string{"centos", "ubuntu"}, FilterPlatforms("linux", []string{"centos", "darwin", "ubuntu", "windows"}))
+	assert.Equal(t, []string{"darwin", "windows"}, FilterPlatforms("", []string{"darwin", "windows"}))
+}
+
+func TestQuarantineConfig(t *testing.T) {
+	conf := `{"options": {"host_identifier": "uuid"}, "schedule": {"uptime": {"query": "SELECT * FROM uptime;", "interval": 3600}}, "packs": {}}`
+	res, err := QuarantineConfig([]byte(conf))
+	assert.NoError(t, err)
+	var parsed map[string]json.RawMessage
+	assert.NoError(t, json.Unmarshal(res, &parsed))
+	assert.Contains(t, parsed, "options")
+	assert.NotContains(t, parsed, "schedule")
+	assert.NotContains(t, parsed, "packs")
+	_, err = QuarantineConfig([]byte("not json"))
+	assert.Error(t, err)
+}
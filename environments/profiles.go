@@ -0,0 +1,224 @@
+package environments
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Valid names for performance profiles
+var profileNameRegexp = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,63}$`)
+
+// PerformanceProfile to define the check-in intervals of a class of nodes in an environment. Nodes get the
+// profile when they have its tag, or when they match all its automatic rules on platform and hardware model.
+// Intervals with 0 keep the value of the environment.
+type PerformanceProfile struct {
+	gorm.Model
+	EnvironmentID  uint `gorm:"index"`
+	Name           string
+	Priority       int
+	Tag            string
+	Platform       string
+	HardwareModel  string
+	ConfigInterval int
+	LogInterval    int
+	QueryInterval  int
+}
+
+// ProfileNode to hold the values of a node used to match performance profiles
+type ProfileNode struct {
+	Platform      string
+	HardwareModel string
+	Tags          []string
+}
+
+// ValidateProfile to check the name, the rules and the intervals of a performance profile
+func ValidateProfile(profile PerformanceProfile) error {
+	if !profileNameRegexp.MatchString(profile.Name) {
+		return fmt.Errorf("invalid name %s, use lowercase letters, digits, dashes and underscores", profile.Name)
+	}
+	if profile.Tag == "" && profile.Platform == "" && profile.HardwareModel == "" {
+		return fmt.Errorf("profile %s needs a tag or a rule on platform or hardware model", profile.Name)
+	}
+	if profile.ConfigInterval < 0 || profile.LogInterval < 0 || profile.QueryInterval < 0 {
+		return fmt.Errorf("invalid intervals for profile %s", profile.Name)
+	}
+	return nil
+}
+
+// Helper to sort profiles by priority and name, so matching is always in the same order
+func sortProfiles(profiles []PerformanceProfile) {
+	sort.SliceStable(profiles, func(i, j int) bool {
+		if profiles[i].Priority != profiles[j].Priority {
+			return profiles[i].Priority < profiles[j].Priority
+		}
+		return profiles[i].Name < profiles[j].Name
+	})
+}
+
+// Helper to check if a node matches the automatic rules of a profile
+func matchRules(profile PerformanceProfile, node ProfileNode) bool {
+	if profile.Platform == "" && profile.HardwareModel == "" {
+		return false
+	}
+	if profile.Platform != "" && !strings.EqualFold(profile.Platform, node.Platform) {
+		return false
+	}
+	if profile.HardwareModel != "" && !strings.Contains(strings.ToLower(node.HardwareModel), strings.ToLower(profile.HardwareModel)) {
+		return false
+	}
+	return true
+}
+
+// MatchProfile to get the profile of a node. Profiles assigned with tags come before automatic rules, and
+// within each, profiles are checked by priority. Returns false if the node has no profile.
+func MatchProfile(profiles []PerformanceProfile, node ProfileNode) (PerformanceProfile, bool) {
+	sorted := make([]PerformanceProfile, len(profiles))
+	copy(sorted, profiles)
+	sortProfiles(sorted)
+	tags := make(map[string]bool, len(node.Tags))
+	for _, t := range node.Tags {
+		tags[t] = true
+	}
+	for _, p := range sorted {
+		if p.Tag != "" && tags[p.Tag] {
+			return p, true
+		}
+	}
+	for _, p := range sorted {
+		if p.Tag == "" && matchRules(p, node) {
+			return p, true
+		}
+	}
+	return PerformanceProfile{}, false
+}
+
+// ProfilesUseTags to check if any of the profiles is assigned with tags
+func ProfilesUseTags(profiles []PerformanceProfile) bool {
+	for _, p := range profiles {
+		if p.Tag != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// WithProfile to get a copy of the environment with the intervals of a profile, used to render per node output
+func (env TLSEnvironment) WithProfile(profile PerformanceProfile) TLSEnvironment {
+	if profile.ConfigInterval > 0 {
+		env.ConfigInterval = profile.ConfigInterval
+	}
+	if profile.LogInterval > 0 {
+		env.LogInterval = profile.LogInterval
+	}
+	if profile.QueryInterval > 0 {
+		env.QueryInterval = profile.QueryInterval
+	}
+	return env
+}
+
+// Helper to change the options of a serialized configuration, keeping the rest of it
+func updateOptions(configuration []byte, update func(options map[string]interface{})) ([]byte, error) {
+	var conf map[string]json.RawMessage
+	if err := json.Unmarshal(configuration, &conf); err != nil {
+		return configuration, fmt.Errorf("Unmarshal %v", err)
+	}
+	options := make(map[string]interface{})
+	if raw, ok := conf["options"]; ok {
+		if err := json.Unmarshal(raw, &options); err != nil {
+			return configuration, fmt.Errorf("Unmarshal options %v", err)
+		}
+	}
+	update(options)
+	var err error
+	if conf["options"], err = json.Marshal(options); err != nil {
+		return configuration, fmt.Errorf("Marshal options %v", err)
+	}
+	res, err := json.MarshalIndent(conf, "", "  ")
+	if err != nil {
+		return configuration, fmt.Errorf("Marshal %v", err)
+	}
+	return res, nil
+}
+
+// ApplyProfile to set config_refresh, logger_tls_period and distributed_interval in the options of a
+// serialized configuration with the intervals of a profile
+func ApplyProfile(configuration []byte, profile PerformanceProfile) ([]byte, error) {
+	return updateOptions(configuration, func(options map[string]interface{}) {
+		if profile.ConfigInterval > 0 {
+			options["config_refresh"] = profile.ConfigInterval
+		}
+		if profile.LogInterval > 0 {
+			options["logger_tls_period"] = profile.LogInterval
+		}
+		if profile.QueryInterval > 0 {
+			options["distributed_interval"] = profile.QueryInterval
+		}
+	})
+}
+
+// Profiles to get the performance profiles of an environment, sorted by priority and name
+func (environment *Environment) Profiles(envid uint) ([]PerformanceProfile, error) {
+	return environment.ProfilesCtx(context.Background(), envid)
+}
+
+// ProfilesCtx to get the performance profiles of an environment, sorted by priority and name
+func (environment *Environment) ProfilesCtx(ctx context.Context, envid uint) ([]PerformanceProfile, error) {
+	var profiles []PerformanceProfile
+	if err := environment.read().WithContext(ctx).Where("environment_id = ?", envid).Order("priority, name").Find(&profiles).Error; err != nil {
+		return profiles, err
+	}
+	return profiles, nil
+}
+
+// SetProfile to create or update a performance profile of an environment, by name
+func (environment *Environment) SetProfile(envid uint, profile PerformanceProfile) (PerformanceProfile, error) {
+	profile.Name = strings.ToLower(strings.TrimSpace(profile.Name))
+	if err := ValidateProfile(profile); err != nil {
+		return profile, err
+	}
+	var existing PerformanceProfile
+	err := environment.DB.Where("environment_id = ? AND name = ?", envid, profile.Name).First(&existing).Error
+	if err == gorm.ErrRecordNotFound {
+		profile.EnvironmentID = envid
+		if err := environment.DB.Create(&profile).Error; err != nil {
+			return profile, fmt.Errorf("Create %v", err)
+		}
+		return profile, nil
+	}
+	if err != nil {
+		return profile, fmt.Errorf("First %v", err)
+	}
+	err = environment.DB.Model(&existing).Updates(map[string]interface{}{
+		"priority":        profile.Priority,
+		"tag":             profile.Tag,
+		"platform":        profile.Platform,
+		"hardware_model":  profile.HardwareModel,
+		"config_interval": profile.ConfigInterval,
+		"log_interval":    profile.LogInterval,
+		"query_interval":  profile.QueryInterval,
+	}).Error
+	if err != nil {
+		return existing, fmt.Errorf("Updates %v", err)
+	}
+	existing.Priority, existing.Tag, existing.Platform, existing.HardwareModel = profile.Priority, profile.Tag, profile.Platform, profile.HardwareModel
+	existing.ConfigInterval, existing.LogInterval, existing.QueryInterval = profile.ConfigInterval, profile.LogInterval, profile.QueryInterval
+	return existing, nil
+}
+
+// DeleteProfile to delete a performance profile of an environment, by name
+func (environment *Environment) DeleteProfile(envid uint, name string) error {
+	res := environment.DB.Unscoped().Where("environment_id = ? AND name = ?", envid, strings.ToLower(name)).Delete(&PerformanceProfile{})
+	if res.Error != nil {
+		return fmt.Errorf("Delete %v", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package environments
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testProfiles = []PerformanceProfile{
+	{Name: "laptop", Priority: 10, Platform: "darwin", HardwareModel: "MacBook", ConfigInterval: 3600, LogInterval: 600},
+	{Name: "server", Priority: 5, Platform: "ubuntu", ConfigInterval: 300, LogInterval: 10, QueryInterval: 30},
+	{Name: "battery", Priority: 20, Tag: "on-battery", ConfigInterval: 7200},
+}
+
+func TestMatchProfileRules(t *testing.T) {
+	p, ok := MatchProfile(testProfiles, ProfileNode{Platform: "darwin", HardwareModel: "MacBookPro18,3"})
+	assert.True(t, ok)
+	assert.Equal(t, "laptop", p.Name)
+	p, ok = MatchProfile(testProfiles, ProfileNode{Platform: "Ubuntu"})
+	assert.True(t, ok)
+	assert.Equal(t, "server", p.Name)
+	_, ok = MatchProfile(testProfiles, ProfileNode{Platform: "darwin", HardwareModel: "Macmini9,1"})
+	assert.False(t, ok)
+}
+
+func TestMatchProfileTagFirst(t *testing.T) {
+	p, ok := MatchProfile(testProfiles, ProfileNode{Platform: "ubuntu", Tags: []string{"on-battery"}})
+	assert.True(t, ok)
+	assert.Equal(t, "battery", p.Name)
+}
+
+func TestMatchProfilePriority(t *testing.T) {
+	profiles := []PerformanceProfile{
+		{Name: "b", Priority: 1, Platform: "ubuntu"},
+		{Name: "a", Priority: 1, Platform: "ubuntu"},
+		{Name: "c", Priority: 0, Platform: "windows"},
+	}
+	p, ok := MatchProfile(profiles, ProfileNode{Platform: "ubuntu"})
+	assert.True(t, ok)
+	assert.Equal(t, "a", p.Name)
+	// Input order is kept
+	assert.Equal(t, "b", profiles[0].Name)
+}
+
+func TestValidateProfile(t *testing.T) {
+	assert.NoError(t, ValidateProfile(PerformanceProfile{Name: "server", Platform: "ubuntu"}))
+	assert.Error(t, ValidateProfile(PerformanceProfile{Name: "Server!", Platform: "ubuntu"}))
+	assert.Error(t, ValidateProfile(PerformanceProfile{Name: "server"}))
+	assert.Error(t, ValidateProfile(PerformanceProfile{Name: "server", Tag: "x", LogInterval: -1}))
+}
+
+func TestWithProfile(t *testing.T) {
+	env := TLSEnvironment{ConfigInterval: 300, LogInterval: 60, QueryInterval: 60}
+	res := env.WithProfile(testProfiles[0])
+	assert.Equal(t, 3600, res.ConfigInterval)
+	assert.Equal(t, 600, res.LogInterval)
+	assert.Equal(t, 60, res.QueryInterval)
+	assert.Equal(t, 300, env.ConfigInterval)
+}
+
+func TestApplyProfile(t *testing.T) {
+	conf := `{"options": {"host_identifier": "uuid", "config_refresh": 300, "logger_tls_period": 60}, "schedule": {}}`
+	res, err := ApplyProfile([]byte(conf), testProfiles[0])
+	assert.NoError(t, err)
+	var parsed struct {
+		Options map[string]interface{} `json:"options"`
+	}
+	assert.NoError(t, json.Unmarshal(res, &parsed))
+	assert.Equal(t, "uuid", parsed.Options["host_identifier"])
+	assert.Equal(t, float64(3600), parsed.Options["config_refresh"])
+	assert.Equal(t, float64(600), parsed.Options["logger_tls_period"])
+	_, ok := parsed.Options["distributed_interval"]
+	assert.False(t, ok)
+}
@@ -0,0 +1,348 @@
+package environments
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// PseudonymPrefix to recognize pseudonymized values in the logs
+	PseudonymPrefix string = "pseu-"
+	// DefaultPseudonymFlush is how often the new pseudonyms are stored
+	DefaultPseudonymFlush = 30 * time.Second
+	// maxPseudonymsSeen is how many stored pseudonyms are remembered, to avoid storing them again
+	maxPseudonymsSeen = 100000
+)
+
+// Parts of result and query logs with the columns of queries
+var resultColumns = []string{"columns", "snapshot", "diffResults", "result"}
+
+// PseudonymMapping to keep the value of each pseudonym of an environment, for authorized re-identification
+type PseudonymMapping struct {
+	ID            uint   `gorm:"primarykey"`
+	EnvironmentID uint   `gorm:"uniqueIndex:idx_pseudonym_env"`
+	Pseudonym     string `gorm:"uniqueIndex:idx_pseudonym_env"`
+	Column        string
+	Value         string
+	CreatedAt     time.Time
+}
+
+// PseudonymConfig to return the pseudonymization of an environment, without the key
+type PseudonymConfig struct {
+	Enabled bool     `json:"enabled"`
+	Columns []string `json:"columns"`
+	Tables  []string `json:"tables"`
+}
+
+// Pseudonymizer to replace the values of configured columns in the logs of an environment with keyed pseudonyms.
+// The same value always gets the same pseudonym, and only osctrl keeps the key and the values.
+type Pseudonymizer struct {
+	EnvironmentID uint
+	Columns       []string
+	Tables        []string
+	key           []byte
+	// Record is called with every pseudonym generated, to store its value
+	Record func(envID uint, column, value, pseudonym string)
+}
+
+// GenPseudonymKey to generate a random key for the pseudonyms of an environment
+func GenPseudonymKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(key), nil
+}
+
+// Helper to split a comma separated list of patterns, lowercase and without empty values
+func splitPatterns(list string) []string {
+	var patterns []string
+	for _, p := range strings.Split(list, ",") {
+		if p = strings.ToLower(strings.TrimSpace(p)); p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+// ValidatePseudonymPatterns to check that the patterns of columns or tables are valid, like username or *_user
+func ValidatePseudonymPatterns(list string) error {
+	for _, p := range splitPatterns(list) {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid pattern %s", p)
+		}
+	}
+	return nil
+}
+
+// PseudonymsOf to get the pseudonymization of an environment
+func PseudonymsOf(env TLSEnvironment) PseudonymConfig {
+	cfg := PseudonymConfig{
+		Columns: splitPatterns(env.PseudonymColumns),
+		Tables:  splitPatterns(env.PseudonymTables),
+	}
+	cfg.Enabled = env.PseudonymKey != "" && (len(cfg.Columns) > 0 || len(cfg.Tables) > 0)
+	return cfg
+}
+
+// NewPseudonymizer to prepare the pseudonymizer of an environment, nil when it is not enabled
+func NewPseudonymizer(env TLSEnvironment) *Pseudonymizer {
+	cfg := PseudonymsOf(env)
+	if !cfg.Enabled {
+		return nil
+	}
+	key, err := hex.DecodeString(env.PseudonymKey)
+	if err != nil || len(key) == 0 {
+		log.Printf("invalid pseudonym key for %s", env.Name)
+		return nil
+	}
+	return &Pseudonymizer{
+		EnvironmentID: env.ID,
+		Columns:       cfg.Columns,
+		Tables:        cfg.Tables,
+		key:           key,
+	}
+}
+
+// Pseudonym to get the pseudonym of a value, as a keyed HMAC so it can not be reversed without osctrl
+func (p *Pseudonymizer) Pseudonym(value string) string {
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write([]byte(value))
+	return PseudonymPrefix + hex.EncodeToString(mac.Sum(nil))[:20]
+}
+
+// Helper to match a name with a list of patterns, case insensitive
+func matchPatterns(patterns []string, name string) bool {
+	name = strings.ToLower(name)
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchColumn to check if the values of a column are pseudonymized
+func (p *Pseudonymizer) MatchColumn(column string) bool {
+	return matchPatterns(p.Columns, column)
+}
+
+// MatchTable to check if all the columns of a table or scheduled query are pseudonymized
+func (p *Pseudonymizer) MatchTable(table string) bool {
+	return matchPatterns(p.Tables, table)
+}
+
+// Helper to pseudonymize one value, recording it
+func (p *Pseudonymizer) replace(column, value string) string {
+	if value == "" || strings.HasPrefix(value, PseudonymPrefix) {
+		return value
+	}
+	pseudonym := p.Pseudonym(value)
+	if p.Record != nil {
+		p.Record(p.EnvironmentID, column, value, pseudonym)
+	}
+	return pseudonym
+}
+
+// Helper to walk a JSON value, pseudonymizing the strings in matching keys or all of them
+func (p *Pseudonymizer) walk(v interface{}, column string, all bool) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for k, nested := range value {
+			value[k] = p.walk(nested, k, all || p.MatchColumn(k))
+		}
+		return value
+	case []interface{}:
+		for i, nested := range value {
+			value[i] = p.walk(nested, column, all)
+		}
+		return value
+	case string:
+		if all {
+			return p.replace(column, value)
+		}
+	}
+	return v
+}
+
+// Row to pseudonymize the columns of one row of results, returning a new row
+func (p *Pseudonymizer) Row(table string, row map[string]string) map[string]string {
+	all := p.MatchTable(table)
+	res := make(map[string]string, len(row))
+	for k, v := range row {
+		if all || p.MatchColumn(k) {
+			v = p.replace(k, v)
+		}
+		res[k] = v
+	}
+	return res
+}
+
+// Transform to pseudonymize a batch of logs, any key matching the columns is replaced wherever it is nested, like
+// in decorations. All the columns of result and query logs of matching queries are replaced too.
+func (p *Pseudonymizer) Transform(data []byte) ([]byte, error) {
+	var batch interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&batch); err != nil {
+		return nil, fmt.Errorf("error parsing logs %v", err)
+	}
+	entries, ok := batch.([]interface{})
+	if !ok {
+		entries = []interface{}{batch}
+	}
+	for _, e := range entries {
+		entry, ok := e.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if name, ok := entry["name"].(string); ok && len(p.Tables) > 0 && p.MatchTable(name) {
+			for _, part := range resultColumns {
+				if nested, ok := entry[part]; ok {
+					entry[part] = p.walk(nested, part, true)
+				}
+			}
+		}
+		p.walk(entry, "", false)
+	}
+	return json.Marshal(batch)
+}
+
+// PseudonymStore to keep the values of the pseudonyms generated by an instance, stored periodically
+type PseudonymStore struct {
+	DB      *gorm.DB
+	mutex   sync.Mutex
+	pending map[string]PseudonymMapping
+	seen    map[string]bool
+}
+
+// CreatePseudonymStore to initialize the store of pseudonyms
+func CreatePseudonymStore(backend *gorm.DB) *PseudonymStore {
+	return &PseudonymStore{
+		DB:      backend,
+		pending: make(map[string]PseudonymMapping),
+		seen:    make(map[string]bool),
+	}
+}
+
+// Record to add a pseudonym and its value, it is stored with the next flush unless it was stored before
+func (s *PseudonymStore) Record(envID uint, column, value, pseudonym string) {
+	if s == nil {
+		return
+	}
+	k := fmt.Sprintf("%d:%s", envID, pseudonym)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.seen[k] {
+		return
+	}
+	s.pending[k] = PseudonymMapping{EnvironmentID: envID, Pseudonym: pseudonym, Column: column, Value: value, CreatedAt: time.Now()}
+}
+
+// Flush to store the new pseudonyms, existing ones are kept
+func (s *PseudonymStore) Flush() error {
+	s.mutex.Lock()
+	pending := s.pending
+	s.pending = make(map[string]PseudonymMapping)
+	s.mutex.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+	mappings := make([]PseudonymMapping, 0, len(pending))
+	for _, m := range pending {
+		mappings = append(mappings, m)
+	}
+	err := s.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "environment_id"}, {Name: "pseudonym"}},
+		DoNothing: true,
+	}).CreateInBatches(&mappings, 500).Error
+	if err != nil {
+		return fmt.Errorf("Create %v", err)
+	}
+	s.mutex.Lock()
+	if len(s.seen)+len(pending) > maxPseudonymsSeen {
+		s.seen = make(map[string]bool)
+	}
+	for k := range pending {
+		s.seen[k] = true
+	}
+	s.mutex.Unlock()
+	return nil
+}
+
+// Start to flush the pseudonyms periodically until the context is done
+func (s *PseudonymStore) Start(ctx context.Context, interval time.Duration) {
+	if s == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				if err := s.Flush(); err != nil {
+					log.Printf("error flushing pseudonyms %v", err)
+				}
+				return
+			case <-ticker.C:
+				if err := s.Flush(); err != nil {
+					log.Printf("error flushing pseudonyms %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// LookupPseudonym to re-identify a pseudonym of an environment
+func (environment *Environment) LookupPseudonym(envID uint, pseudonym string) (PseudonymMapping, error) {
+	var m PseudonymMapping
+	if err := environment.DB.Where("environment_id = ? AND pseudonym = ?", envID, strings.TrimSpace(pseudonym)).First(&m).Error; err != nil {
+		return m, err
+	}
+	return m, nil
+}
+
+// UpdatePseudonyms to set the columns and tables pseudonymized in the logs of an environment, the key is generated
+// the first time. Empty columns and tables disable it, and the key is kept so pseudonyms do not change.
+func (environment *Environment) UpdatePseudonyms(idEnv, columns, tables string) error {
+	env, err := environment.Get(idEnv)
+	if err != nil {
+		return fmt.Errorf("error getting environment %v", err)
+	}
+	if err := ValidatePseudonymPatterns(columns); err != nil {
+		return err
+	}
+	if err := ValidatePseudonymPatterns(tables); err != nil {
+		return err
+	}
+	toUpdate := map[string]interface{}{
+		"pseudonym_columns": strings.Join(splitPatterns(columns), ","),
+		"pseudonym_tables":  strings.Join(splitPatterns(tables), ","),
+	}
+	if env.PseudonymKey == "" {
+		key, err := GenPseudonymKey()
+		if err != nil {
+			return fmt.Errorf("error generating key %v", err)
+		}
+		toUpdate["pseudonym_key"] = key
+	}
+	if err := environment.DB.Model(&env).Updates(toUpdate).Error; err != nil {
+		return fmt.Errorf("Updates %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package environments
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPseudonymKey = "000102030405060708090a0b0c0d0e0f000102030405060708090a0b0c0d0e0f"
+
+func testPseudonymizer(columns, tables string) *Pseudonymizer {
+	return NewPseudonymizer(TLSEnvironment{Name: "prod", PseudonymColumns: columns, PseudonymTables: tables, PseudonymKey: testPseudonymKey})
+}
+
+func TestNewPseudonymizerDisabled(t *testing.T) {
+	assert.Nil(t, NewPseudonymizer(TLSEnvironment{Name: "prod"}))
+	assert.Nil(t, NewPseudonymizer(TLSEnvironment{Name: "prod", PseudonymKey: testPseudonymKey}))
+	assert.Nil(t, NewPseudonymizer(TLSEnvironment{Name: "prod", PseudonymColumns: "username"}))
+	assert.Nil(t, NewPseudonymizer(TLSEnvironment{Name: "prod", PseudonymColumns: "username", PseudonymKey: "invalid"}))
+	assert.NotNil(t, testPseudonymizer("username", ""))
+}
+
+func TestPseudonymConsistent(t *testing.T) {
+	p := testPseudonymizer("username", "")
+	assert.Equal(t, p.Pseudonym("alice"), p.Pseudonym("alice"))
+	assert.NotEqual(t, p.Pseudonym("alice"), p.Pseudonym("bob"))
+	assert.True(t, strings.HasPrefix(p.Pseudonym("alice"), PseudonymPrefix))
+	other := NewPseudonymizer(TLSEnvironment{Name: "dev", PseudonymColumns: "username", PseudonymKey: strings.Repeat("ff", 32)})
+	assert.NotEqual(t, p.Pseudonym("alice"), other.Pseudonym("alice"))
+}
+
+func TestPseudonymMatch(t *testing.T) {
+	p := testPseudonymizer(" Username, *_user ,host*", "pack_users_*")
+	assert.True(t, p.MatchColumn("username"))
+	assert.True(t, p.MatchColumn("USERNAME"))
+	assert.True(t, p.MatchColumn("logged_user"))
+	assert.True(t, p.MatchColumn("hostname"))
+	assert.False(t, p.MatchColumn("uid"))
+	assert.True(t, p.MatchTable("pack_users_logged"))
+	assert.False(t, p.MatchTable("processes"))
+	assert.Error(t, ValidatePseudonymPatterns("user[name"))
+	assert.NoError(t, ValidatePseudonymPatterns("username,*_user,"))
+}
+
+func TestPseudonymTransformResults(t *testing.T) {
+	var recorded []string
+	p := testPseudonymizer("username,hostname", "")
+	p.Record = func(envID uint, column, value, pseudonym string) {
+		recorded = append(recorded, column+"="+value)
+	}
+	data := `[{"name":"users","hostIdentifier":"host1","unixTime":1650000000,"columns":{"username":"alice","uid":"501"},"decorations":{"hostname":"laptop-alice","labels":{"username":"alice"}}},` +
+		`{"name":"users","snapshot":[{"username":"bob","uid":"502"}],"diffResults":{"added":[{"username":"carol"}],"removed":[]}}]`
+	out, err := p.Transform([]byte(data))
+	require.NoError(t, err)
+	for _, leaked := range []string{"alice", "bob", "carol", "laptop-alice"} {
+		assert.NotContains(t, string(out), leaked)
+	}
+	assert.Contains(t, string(out), p.Pseudonym("alice"))
+	assert.Contains(t, string(out), `"uid":"501"`)
+	assert.Contains(t, string(out), `"unixTime":1650000000`)
+	assert.Contains(t, recorded, "hostname=laptop-alice")
+	// Pseudonymized logs are not changed again
+	again, err := p.Transform(out)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(out), string(again))
+}
+
+func TestPseudonymTransformTables(t *testing.T) {
+	p := testPseudonymizer("", "pack_users_*")
+	data := `[{"name":"pack_users_logged","columns":{"user":"alice","tty":"ttys000"}},{"name":"processes","columns":{"user":"alice"}}]`
+	out, err := p.Transform([]byte(data))
+	require.NoError(t, err)
+	var logs []struct {
+		Columns map[string]string `json:"columns"`
+	}
+	require.NoError(t, json.Unmarshal(out, &logs))
+	assert.Equal(t, p.Pseudonym("alice"), logs[0].Columns["user"])
+	assert.Equal(t, p.Pseudonym("ttys000"), logs[0].Columns["tty"])
+	assert.Equal(t, "alice", logs[1].Columns["user"])
+	// Query logs of on-demand queries
+	out, err = p.Transform([]byte(`{"name":"pack_users_now","result":[{"user":"alice"}],"status":0}`))
+	require.NoError(t, err)
+	assert.NotContains(t, string(out), "alice")
+	assert.Contains(t, string(out), `"status":0`)
+}
+
+func TestPseudonymTransformInvalid(t *testing.T) {
+	p := testPseudonymizer("username", "")
+	_, err := p.Transform([]byte(`[{"username":`))
+	assert.Error(t, err)
+}
+
+func TestPseudonymRow(t *testing.T) {
+	p := testPseudonymizer("username", "")
+	row := map[string]string{"username": "alice", "uid": "501"}
+	res := p.Row("users", row)
+	assert.Equal(t, p.Pseudonym("alice"), res["username"])
+	assert.Equal(t, "501", res["uid"])
+	assert.Equal(t, "alice", row["username"])
+}
+
+func TestPseudonymsOf(t *testing.T) {
+	cfg := PseudonymsOf(TLSEnvironment{PseudonymColumns: "username,,", PseudonymKey: testPseudonymKey})
+	assert.True(t, cfg.Enabled)
+	assert.Equal(t, []string{"username"}, cfg.Columns)
+	assert.False(t, PseudonymsOf(TLSEnvironment{PseudonymKey: testPseudonymKey}).Enabled)
+}
@@ -0,0 +1,302 @@
+package environments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jmpsec/osctrl/backend"
+	"gorm.io/gorm"
+)
+
+// States of a scheduled change of the configuration
+const (
+	ScheduledPending   string = "pending"
+	ScheduledApplied   string = "applied"
+	ScheduledCancelled string = "cancelled"
+	ScheduledReplaced  string = "replaced"
+	ScheduledFailed    string = "failed"
+)
+
+const (
+	// DefaultScheduledInterval is how often scheduled changes are checked to be applied
+	DefaultScheduledInterval = time.Minute
+	// ScheduledTimeFormat is the format of apply times in summaries
+	ScheduledTimeFormat = "2006-01-02 15:04:05 MST"
+)
+
+var (
+	// ErrPendingChange is returned when scheduling a change with another one pending and not replacing it
+	ErrPendingChange = errors.New("environment already has a pending scheduled change")
+	// ErrNoPendingChange is returned when there is no pending change to cancel or apply
+	ErrNoPendingChange = errors.New("no pending scheduled change")
+)
+
+// ScheduledChange to keep a change of one section of the configuration to be applied at a later time.
+// Before holds the value of the section when the change was scheduled, so changes made since are not overwritten.
+type ScheduledChange struct {
+	gorm.Model
+	EnvironmentID  uint `gorm:"index"`
+	Section        string
+	Value          string
+	Before         string
+	BaseVersion    int
+	ApplyAt        time.Time `gorm:"index"`
+	Author         string
+	Note           string
+	State          string `gorm:"index"`
+	ClosedBy       string
+	ClosedAt       time.Time
+	AppliedVersion int
+	Error          string
+}
+
+// Diff to get the differences between the section when the change was scheduled and the scheduled value
+func (c ScheduledChange) Diff() []DiffRow {
+	return SideBySide(DiffLines(c.Before, c.Value))
+}
+
+// Helper to describe a scheduled change in the activity of an environment
+func scheduledSummary(c ScheduledChange, action string) string {
+	summary := fmt.Sprintf("%s change scheduled by %s for %s %s", c.Section, c.Author, c.ApplyAt.UTC().Format(ScheduledTimeFormat), action)
+	if c.Note != "" {
+		summary += ": " + c.Note
+	}
+	return summary
+}
+
+// ScheduleConfigChange to schedule a change of one section of the configuration of an environment.
+// Only one change can be pending per environment, the pending one is kept unless replace is set.
+func (environment *Environment) ScheduleConfigChange(env TLSEnvironment, section, value string, applyAt time.Time, author, note string, replace bool, now time.Time) (ScheduledChange, error) {
+	change := ScheduledChange{
+		EnvironmentID: env.ID,
+		Section:       section,
+		Value:         value,
+		Before:        versionOf(env).Section(section),
+		BaseVersion:   env.ConfigVersion,
+		ApplyAt:       applyAt,
+		Author:        author,
+		Note:          note,
+		State:         ScheduledPending,
+	}
+	if !IsConfigKind(section) {
+		return change, fmt.Errorf("unknown section %s", section)
+	}
+	if !applyAt.After(now) {
+		return change, fmt.Errorf("apply time %s is not in the future", applyAt.UTC().Format(ScheduledTimeFormat))
+	}
+	// Make sure the change can be applied as it is
+	if _, err := environment.ProposedConfig(env, section, value); err != nil {
+		return change, err
+	}
+	err := environment.DB.Transaction(func(tx *gorm.DB) error {
+		var pending []ScheduledChange
+		if err := tx.Where("environment_id = ? AND state = ?", env.ID, ScheduledPending).Find(&pending).Error; err != nil {
+			return fmt.Errorf("Find %v", err)
+		}
+		if len(pending) > 0 && !replace {
+			return ErrPendingChange
+		}
+		txEnv := &Environment{DB: tx}
+		for _, p := range pending {
+			err := tx.Model(&ScheduledChange{}).Where("id = ?", p.ID).Updates(map[string]interface{}{
+				"state":     ScheduledReplaced,
+				"closed_by": author,
+				"closed_at": now,
+			}).Error
+			if err != nil {
+				return fmt.Errorf("Updates %v", err)
+			}
+			if err := txEnv.RecordActivity(env.ID, ActivityScheduled, author, scheduledSummary(p, "replaced"), p.Value, value); err != nil {
+				return err
+			}
+		}
+		if err := tx.Create(&change).Error; err != nil {
+			return fmt.Errorf("Create %v", err)
+		}
+		return txEnv.RecordActivity(env.ID, ActivityScheduled, author, scheduledSummary(change, "created"), change.Before, change.Value)
+	})
+	return change, err
+}
+
+// PendingChange to get the pending scheduled change of an environment, if there is one
+func (environment *Environment) PendingChange(envid uint) (ScheduledChange, bool, error) {
+	var changes []ScheduledChange
+	if err := environment.read().Where("environment_id = ? AND state = ?", envid, ScheduledPending).Order("id DESC").Limit(1).Find(&changes).Error; err != nil {
+		return ScheduledChange{}, false, err
+	}
+	if len(changes) == 0 {
+		return ScheduledChange{}, false, nil
+	}
+	return changes[0], true, nil
+}
+
+// ScheduledChanges to get the latest scheduled changes of an environment, newest first
+func (environment *Environment) ScheduledChanges(envid uint, limit int) ([]ScheduledChange, error) {
+	var changes []ScheduledChange
+	if err := environment.read().Where("environment_id = ?", envid).Order("id DESC").Limit(limit).Find(&changes).Error; err != nil {
+		return changes, err
+	}
+	return changes, nil
+}
+
+// CancelConfigChange to cancel the pending scheduled change of an environment
+func (environment *Environment) CancelConfigChange(envid uint, actor string, now time.Time) (ScheduledChange, error) {
+	var change ScheduledChange
+	err := environment.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("environment_id = ? AND state = ?", envid, ScheduledPending).Order("id DESC").Limit(1).Find(&change).Error; err != nil {
+			return fmt.Errorf("Find %v", err)
+		}
+		if change.ID == 0 {
+			return ErrNoPendingChange
+		}
+		res := tx.Model(&ScheduledChange{}).Where("id = ? AND state = ?", change.ID, ScheduledPending).Updates(map[string]interface{}{
+			"state":     ScheduledCancelled,
+			"closed_by": actor,
+			"closed_at": now,
+		})
+		if res.Error != nil {
+			return fmt.Errorf("Updates %v", res.Error)
+		}
+		if res.RowsAffected == 0 {
+			return ErrNoPendingChange
+		}
+		change.State = ScheduledCancelled
+		change.ClosedBy = actor
+		change.ClosedAt = now
+		return (&Environment{DB: tx}).RecordActivity(envid, ActivityScheduled, actor, scheduledSummary(change, "cancelled"), change.Value, "")
+	})
+	return change, err
+}
+
+// DueChanges to get the pending scheduled changes of all environments that are due to be applied
+func (environment *Environment) DueChanges(now time.Time) ([]ScheduledChange, error) {
+	var changes []ScheduledChange
+	if err := environment.DB.Where("state = ? AND apply_at <= ?", ScheduledPending, now).Order("apply_at").Find(&changes).Error; err != nil {
+		return changes, err
+	}
+	return changes, nil
+}
+
+// ApplyConfigChange to apply a due scheduled change in one transaction, bumping the configuration version.
+// Changes whose section was modified since they were scheduled fail instead of overwriting it.
+// The applied or failed change is returned with the environment before applying it.
+func (environment *Environment) ApplyConfigChange(change ScheduledChange, now time.Time) (TLSEnvironment, ScheduledChange, error) {
+	var env TLSEnvironment
+	var applyErr error
+	err := environment.DB.Transaction(func(tx *gorm.DB) error {
+		// Claim the change, so it is only applied once
+		res := tx.Model(&ScheduledChange{}).Where("id = ? AND state = ?", change.ID, ScheduledPending).Updates(map[string]interface{}{
+			"state":     ScheduledApplied,
+			"closed_at": now,
+		})
+		if res.Error != nil {
+			return fmt.Errorf("Updates %v", res.Error)
+		}
+		if res.RowsAffected == 0 {
+			return ErrNoPendingChange
+		}
+		change.ClosedAt = now
+		if err := tx.Where("id = ?", change.EnvironmentID).First(&env).Error; err != nil {
+			return fmt.Errorf("First %v", err)
+		}
+		proposed, err := environment.ProposedServed(env, change.Section, change.Value)
+		if err == nil && !sameSection(versionOf(env).Section(change.Section), change.Before) {
+			err = fmt.Errorf("%s changed since the change was scheduled", change.Section)
+		}
+		if err != nil {
+			applyErr = err
+			change.State = ScheduledFailed
+			change.Error = err.Error()
+			if err := tx.Model(&ScheduledChange{}).Where("id = ?", change.ID).Updates(map[string]interface{}{"state": ScheduledFailed, "error": change.Error}).Error; err != nil {
+				return fmt.Errorf("Updates %v", err)
+			}
+			return (&Environment{DB: tx}).RecordActivity(env.ID, ActivityScheduled, change.Author, scheduledSummary(change, "failed: "+change.Error), change.Value, "")
+		}
+		err = tx.Model(&TLSEnvironment{}).Where("id = ?", env.ID).Updates(map[string]interface{}{
+			"options":       proposed.Options,
+			"schedule":      proposed.Schedule,
+			"packs":         proposed.Packs,
+			"decorators":    proposed.Decorators,
+			"atc":           proposed.ATC,
+			"flags":         proposed.Flags,
+			"configuration": proposed.Configuration,
+		}).Error
+		if err != nil {
+			return fmt.Errorf("Updates %v", err)
+		}
+		// The activity records the change and the new version
+		txEnv := &Environment{DB: tx}
+		summary := scheduledSummary(change, "applied at "+now.UTC().Format(ScheduledTimeFormat))
+		if err := txEnv.recordChange(env, change.Section, change.Author, summary, ""); err != nil {
+			return err
+		}
+		var version ConfigVersion
+		if err := tx.Where("environment_id = ?", env.ID).Order("version DESC").Limit(1).Find(&version).Error; err != nil {
+			return fmt.Errorf("Find %v", err)
+		}
+		change.State = ScheduledApplied
+		change.AppliedVersion = version.Version
+		if err := tx.Model(&ScheduledChange{}).Where("id = ?", change.ID).Update("applied_version", version.Version).Error; err != nil {
+			return fmt.Errorf("Update %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return env, change, err
+	}
+	return env, change, applyErr
+}
+
+// RunScheduledChanges to apply all the due scheduled changes. Only the instance holding the leader lock does it,
+// the rest skip it. Applied and failed changes are passed to onApply with the environment before the change.
+func (environment *Environment) RunScheduledChanges(leader *backend.LeaderLock, now time.Time, onApply func(TLSEnvironment, ScheduledChange, error)) {
+	if leader != nil {
+		isLeader, err := leader.Acquire()
+		if err != nil {
+			log.Printf("error acquiring leader lock %v", err)
+			return
+		}
+		if !isLeader {
+			return
+		}
+	}
+	due, err := environment.DueChanges(now)
+	if err != nil {
+		log.Printf("error getting scheduled changes %v", err)
+		return
+	}
+	for _, c := range due {
+		env, applied, err := environment.ApplyConfigChange(c, now)
+		if errors.Is(err, ErrNoPendingChange) {
+			continue
+		}
+		if err != nil {
+			log.Printf("error applying scheduled change %d %v", c.ID, err)
+		}
+		if env.ID != 0 && onApply != nil {
+			onApply(env, applied, err)
+		}
+	}
+}
+
+// StartScheduledChanges to apply scheduled changes of the configuration periodically
+func (environment *Environment) StartScheduledChanges(ctx context.Context, leader *backend.LeaderLock, interval time.Duration, onApply func(TLSEnvironment, ScheduledChange, error)) {
+	if interval <= 0 {
+		interval = DefaultScheduledInterval
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				environment.RunScheduledChanges(leader, time.Now(), onApply)
+			}
+		}
+	}()
+}
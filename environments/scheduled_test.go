@@ -0,0 +1,122 @@
+package environments
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func mockScheduled(t *testing.T) (*Environment, sqlmock.Sqlmock) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db, got error: %v", err)
+	}
+	t.Cleanup(func() { mockDB.Close() })
+	_postgres, err := gorm.Open(postgres.New(postgres.Config{Conn: mockDB}), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("unable to create new postgres database: %v", err)
+	}
+	return &Environment{DB: _postgres}, mock
+}
+
+func TestScheduleConfigChangeInvalid(t *testing.T) {
+	e, mock := mockScheduled(t)
+	now := time.Date(2022, 3, 8, 14, 0, 0, 0, time.UTC)
+	env := TLSEnvironment{Name: "dev", Options: `{"a": 1}`}
+	env.ID = 1
+	_, err := e.ScheduleConfigChange(env, ActivityOptions, `{"a": 2}`, now.Add(-time.Minute), "admin", "", false, now)
+	assert.Error(t, err)
+	_, err = e.ScheduleConfigChange(env, ActivityStorm, "10", now.Add(time.Hour), "admin", "", false, now)
+	assert.Error(t, err)
+	_, err = e.ScheduleConfigChange(env, ActivityConfiguration, "not json", now.Add(time.Hour), "admin", "", false, now)
+	assert.Error(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestScheduleConfigChangePending(t *testing.T) {
+	e, mock := mockScheduled(t)
+	now := time.Date(2022, 3, 8, 14, 0, 0, 0, time.UTC)
+	env := TLSEnvironment{Name: "dev", Options: `{"a": 1}`}
+	env.ID = 1
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM "scheduled_changes" WHERE \(environment_id = \$1 AND state = \$2\)`).
+		WithArgs(1, ScheduledPending).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "environment_id", "section", "state"}).AddRow(3, 1, ActivityPacks, ScheduledPending))
+	mock.ExpectRollback()
+	_, err := e.ScheduleConfigChange(env, ActivityOptions, `{"a": 2}`, now.Add(time.Hour), "admin", "", false, now)
+	assert.ErrorIs(t, err, ErrPendingChange)
+	// Replacing the pending change
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM "scheduled_changes"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "environment_id", "section", "state"}).AddRow(3, 1, ActivityPacks, ScheduledPending))
+	mock.ExpectExec(`UPDATE "scheduled_changes" SET "closed_at"=\$1,"closed_by"=\$2,"state"=\$3`).
+		WithArgs(now, "admin", ScheduledReplaced, sqlmock.AnyArg(), 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO "env_activities"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectQuery(`INSERT INTO "scheduled_changes"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+	mock.ExpectQuery(`INSERT INTO "env_activities"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	mock.ExpectCommit()
+	change, err := e.ScheduleConfigChange(env, ActivityOptions, `{"a": 2}`, now.Add(time.Hour), "admin", "window", true, now)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(4), change.ID)
+	assert.Equal(t, `{"a": 1}`, change.Before)
+	assert.Equal(t, ScheduledPending, change.State)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCancelConfigChangeNone(t *testing.T) {
+	e, mock := mockScheduled(t)
+	now := time.Date(2022, 3, 8, 14, 0, 0, 0, time.UTC)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM "scheduled_changes"`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectRollback()
+	_, err := e.CancelConfigChange(1, "admin", now)
+	assert.ErrorIs(t, err, ErrNoPendingChange)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestApplyConfigChangeAlreadyClaimed(t *testing.T) {
+	e, mock := mockScheduled(t)
+	now := time.Date(2022, 3, 8, 14, 0, 0, 0, time.UTC)
+	change := ScheduledChange{EnvironmentID: 1, Section: ActivityOptions, Value: `{"a": 2}`, Before: `{"a": 1}`, State: ScheduledPending}
+	change.ID = 4
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "scheduled_changes" SET "closed_at"=\$1,"state"=\$2,"updated_at"=\$3 WHERE \(id = \$4 AND state = \$5\)`).
+		WithArgs(now, ScheduledApplied, sqlmock.AnyArg(), 4, ScheduledPending).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	_, _, err := e.ApplyConfigChange(change, now)
+	assert.ErrorIs(t, err, ErrNoPendingChange)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestApplyConfigChangeConflict(t *testing.T) {
+	e, mock := mockScheduled(t)
+	now := time.Date(2022, 3, 8, 14, 0, 0, 0, time.UTC)
+	change := ScheduledChange{EnvironmentID: 1, Section: ActivityOptions, Value: `{"a": 2}`, Before: `{"a": 1}`, Author: "admin", State: ScheduledPending}
+	change.ID = 4
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "scheduled_changes"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	// Options were changed by hand since the change was scheduled
+	mock.ExpectQuery(`SELECT \* FROM "tls_environments"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "options", "schedule", "packs", "decorators", "atc"}).AddRow(1, "dev", `{"a": 3}`, "{}", "{}", "{}", "{}"))
+	mock.ExpectExec(`UPDATE "scheduled_changes" SET "error"=\$1,"state"=\$2`).
+		WithArgs("options changed since the change was scheduled", ScheduledFailed, sqlmock.AnyArg(), 4).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO "env_activities"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+	env, failed, err := e.ApplyConfigChange(change, now)
+	assert.Error(t, err)
+	assert.Equal(t, "dev", env.Name)
+	assert.Equal(t, ScheduledFailed, failed.State)
+	assert.Contains(t, failed.Error, "options changed")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestScheduledSummary(t *testing.T) {
+	c := ScheduledChange{Section: ActivityPacks, Author: "admin", ApplyAt: time.Date(2022, 3, 8, 22, 0, 0, 0, time.UTC), Note: "window"}
+	assert.Equal(t, "packs change scheduled by admin for 2022-03-08 22:00:00 UTC created: window", scheduledSummary(c, "created"))
+	c.Note = ""
+	assert.Equal(t, "packs change scheduled by admin for 2022-03-08 22:00:00 UTC cancelled", scheduledSummary(c, "cancelled"))
+}
@@ -4,11 +4,11 @@ package environments
 const QuickAddScriptShell = `
 #!/bin/sh
 #
-# {{ .Project }} - Tool to quick-add OSX/Linux nodes
+# {{ .Project }} - {{ T "script.add.unix" }}
 #
-# IMPORTANT! If osquery is not installed, it will be installed.
+# {{ T "script.add.important" }}
 
-# Use TRACE=1 to debug script
+# {{ T "script.trace" }}
 if [ "$TRACE" = "1" ]; then
   set -o xtrace
 fi
@@ -32,10 +32,15 @@ _CERT_FREEBSD=/usr/local/etc/certs/${_PROJECT}.crt
 
 _DEB_ARCH=$(dpkg --print-architecture)
 
-_OSQUERY_VER="{{ .OsqueryVersion }}"
-_OSQUERY_PKG="https://osquery-packages.s3.amazonaws.com/darwin/osquery-$_OSQUERY_VER.pkg"
-_OSQUERY_DEB="https://osquery-packages.s3.amazonaws.com/deb/osquery_$_OSQUERY_VER-1.linux_$_DEB_ARCH.deb"
-_OSQUERY_RPM="https://osquery-packages.s3.amazonaws.com/rpm/osquery-$_OSQUERY_VER-1.linux.x86_64.rpm"
+_OSQUERY_VER_LINUX="{{ .Versions.Linux.Version }}"
+_OSQUERY_VER_DARWIN="{{ .Versions.Darwin.Version }}"
+_OSQUERY_PINNED_LINUX="{{ if .Versions.Linux.Pinned }}1{{ end }}"
+_OSQUERY_PINNED_DARWIN="{{ if .Versions.Darwin.Pinned }}1{{ end }}"
+_OSQUERY_VER="$_OSQUERY_VER_LINUX"
+_OSQUERY_PINNED="$_OSQUERY_PINNED_LINUX"
+_OSQUERY_PKG="{{ .PackagesURL }}/darwin/osquery-$_OSQUERY_VER_DARWIN.pkg"
+_OSQUERY_DEB="{{ .PackagesURL }}/deb/osquery_$_OSQUERY_VER_LINUX-1.linux_$_DEB_ARCH.deb"
+_OSQUERY_RPM="{{ .PackagesURL }}/rpm/osquery-$_OSQUERY_VER_LINUX-1.linux.x86_64.rpm"
 
 _OSQUERY_SERVICE_LINUX="osqueryd"
 _OSQUERY_SERVICE_OSX="io.osquery.agent"
@@ -65,7 +70,7 @@ installOsquery() {
       log "RPM based system detected"
       local _RPM="$(echo $_OSQUERY_RPM | cut -d"/" -f5)"
       sudo curl -# "$_OSQUERY_RPM" -o "/tmp/$_RPM"
-      sudo rpm -ivh "/tmp/$_RPM"
+      sudo rpm -Uvh --oldpackage "/tmp/$_RPM"
     else
       log "DEB based system detected"
       local _DEB="$(echo $_OSQUERY_DEB | cut -d"/" -f5)"
@@ -98,7 +103,10 @@ verifyOsquery() {
     installOsquery
   else
     local osquery_version=$(osqueryi -version | cut -d' ' -f3)
-    if [ "$(echo "$_OSQUERY_VER:$osquery_version" | tr ':' '\n' | sort -rV | head -n 1)" != "$_OSQUERY_VER" ]; then
+    if [ "$_OSQUERY_PINNED" = "1" ] && [ "$osquery_version" != "$_OSQUERY_VER" ]; then
+      log "Installed version of osquery is $osquery_version, pinned version is $_OSQUERY_VER"
+      installOsquery
+    elif [ "$(echo "$_OSQUERY_VER:$osquery_version" | tr ':' '\n' | sort -rV | head -n 1)" != "$_OSQUERY_VER" ]; then
       log "Installed version of osquery is $osquery_version, needs to upgrade to $_OSQUERY_VER"
       installOsquery
     else
@@ -121,6 +129,8 @@ whatOS() {
     _FLAGS="$_FLAGS_OSX"
     _CERT="$_CERT_OSX"
     _SERVICE="$_OSQUERY_SERVICE_OSX"
+    _OSQUERY_VER="$_OSQUERY_VER_DARWIN"
+    _OSQUERY_PINNED="$_OSQUERY_PINNED_DARWIN"
   fi
   if [ "$_OS" = "freebsd" ]; then
     _SECRET_FILE="$_SECRET_FREEBSD"
@@ -131,7 +141,7 @@ whatOS() {
   log "_SECRET_FILE=$_SECRET_FILE"
   log "_FLAGS=$_FLAGS"
   log "_CERT=$_CERT"
-  log "IMPORTANT! If osquery is not installed, it will be installed."
+  log "{{ T "script.add.important" }}"
 }
 
 stopOsquery() {
@@ -162,6 +172,11 @@ stopOsquery() {
 prepareSecret() {
   log "Preparing osquery secret in $_SECRET_FILE"
   echo "$_SECRET" | sudo tee "$_SECRET_FILE"
+  # {{ T "script.metadata" }}
+  env | grep '^OSCTRL_META_' | sed -e 's/^OSCTRL_META_//' | while IFS='=' read -r _name _value; do
+    log "Adding enroll metadata $_name"
+    echo "$(echo "$_name" | tr 'A-Z' 'a-z')=$_value" | sudo tee -a "$_SECRET_FILE" >/dev/null
+  done
   sudo chmod 700 "$_SECRET_FILE"
 }
 
@@ -206,7 +221,7 @@ startOsquery() {
 bye() {
   result=$?
   if [ "$result" != "0" ]; then
-    echo "[!] Fail to enroll $_PROJECT node" >&2
+    echo "[!] {{ T "script.add.failed" "$_PROJECT" }}" >&2
   fi
   exit $result
 }
@@ -220,8 +235,8 @@ prepareFlags
 prepareCert
 startOsquery
 
-log "Congratulations! The node has been enrolled in $_PROJECT"
-log "REMINDER! $_SERVICE has been started and enabled."
+log "{{ T "script.add.success" "$_PROJECT" }}"
+log "{{ T "script.add.reminder" "$_SERVICE" }}"
 
 # EOF
 `
@@ -229,9 +244,9 @@ log "REMINDER! $_SERVICE has been started and enabled."
 // QuickAddScriptPowershell to keep the raw template for the quick add powershell script
 const QuickAddScriptPowershell = `
 ##
-## {{ .Project }} - Tool to quick-add Windows nodes
+## {{ .Project }} - {{ T "script.add.windows" }}
 ##
-## IMPORTANT! If osquery is not installed, it will be installed.
+## {{ T "script.add.important" }}
 
 ## Set-ExecutionPolicy Bypass -Scope Process -Force; iex ((New-Object System.Net.WebClient).DownloadString('https://host/path/test.ps1'))
 
@@ -254,8 +269,10 @@ $osqueryDaemon = (Join-Path $daemonFolder "osqueryd.exe")
 $secretFile = (Join-Path $osqueryPath "{{ .Project }}.secret")
 $flagsFile = (Join-Path $osqueryPath "osquery.flags")
 $certFile = (Join-Path $osqueryPath "{{ .Project }}.crt")
-$osqueryMSI = "https://osquery-packages.s3.amazonaws.com/windows/osquery-{{ .OsqueryVersion }}.msi"
-$osqueryTempMSI = "C:\Windows\Temp\osquery-{{ .OsqueryVersion }}.msi"
+$osqueryVersion = "{{ .Versions.Windows.Version }}"
+$osqueryPinned = {{ if .Versions.Windows.Pinned }}$true{{ else }}$false{{ end }}
+$osqueryMSI = "{{ .PackagesURL }}/windows/osquery-$osqueryVersion.msi"
+$osqueryTempMSI = "C:\Windows\Temp\osquery-$osqueryVersion.msi"
 #$osqueryMSISize = 9953280
 $serviceName = "osqueryd"
 $serviceDescription = "osquery daemon service"
@@ -397,26 +414,36 @@ function Add-ToSystemPath {
   }
 }
 
+function Install-Osquery {
+  Write-Host "[+] Downloading osquery $osqueryVersion"
+  (New-Object System.Net.WebClient).DownloadFile($osqueryMSI, $osqueryTempMSI)
+  #do {
+  #  Start-Sleep -Seconds 2
+  #  $fileSize= (Get-Item $osqueryTempMSI).Length
+  #} until ($fileSize -eq $osqueryMSISize)
+  Write-Host "[+] Installing osquery"
+  msiexec /i $osqueryTempMSI /passive /norestart /qn
+  Start-Sleep -Seconds 5
+}
+
 function QuickAdd-Node {
   # Make sure we are admin
   if (-not (Test-IsAdmin)) {
-    Write-Host "[!] Please run this script with Admin privileges!" -foregroundcolor Red
+    Write-Host "[!] {{ T "script.admin" }}" -foregroundcolor Red
     Exit -1
   }
   # Verify osquery
   if (!(Test-Path $osqueryDaemon)) {
-    Write-Host "[+] $projectName needs osquery"
-    Write-Host "[+] Downloading osquery"
-    (New-Object System.Net.WebClient).DownloadFile($osqueryMSI, $osqueryTempMSI)
-    #do {
-    #  Start-Sleep -Seconds 2
-    #  $fileSize= (Get-Item $osqueryTempMSI).Length
-    #} until ($fileSize -eq $osqueryMSISize)
-    Write-Host "[+] Installing osquery"
-    msiexec /i $osqueryTempMSI /passive /norestart /qn
-    Start-Sleep -Seconds 5
+    Write-Host "[+] $projectName needs osquery $osqueryVersion"
+    Install-Osquery
   } else {
-    Write-Host "[+] osquery is installed"
+    $osqueryInstalled = ((& $osqueryDaemon --version) -split ' ')[-1]
+    if ($osqueryPinned -and ($osqueryInstalled -ne $osqueryVersion)) {
+      Write-Host "[+] Installed version of osquery is $osqueryInstalled, pinned version is $osqueryVersion"
+      Install-Osquery
+    } else {
+      Write-Host "[+] osquery $osqueryInstalled is installed"
+    }
   }
 
   # Lastly, ensure that the Deny Write ACLs have been removed before modifying
@@ -449,6 +476,11 @@ function QuickAdd-Node {
     New-Item -ItemType "file" -Path $secretFile
   }
   $projectSecret | Out-File -FilePath $secretFile -Encoding ASCII
+  # {{ T "script.metadata" }}
+  Get-ChildItem env: | Where-Object { $_.Name -like "OSCTRL_META_*" } | ForEach-Object {
+    Write-Host "[+] Adding enroll metadata $($_.Name.Substring(12))"
+    ($_.Name.Substring(12).ToLower() + "=" + $_.Value) | Out-File -FilePath $secretFile -Encoding ASCII -Append
+  }
 
   # Prepare flags
   Write-Host "[+] Preparing osquery flags"
@@ -498,8 +530,8 @@ function QuickAdd-Node {
   Write-Host "[+] Adding osquery to path"
   Add-ToSystemPath $targetFolder
 
-  Write-Host "Congratulations! The node has been enrolled in $projectName"
-  Write-Host "REMINDER! $serviceName has been started and enabled."
+  Write-Host "{{ T "script.add.success" "$projectName" }}"
+  Write-Host "{{ T "script.add.reminder" "$serviceName" }}"
 }
 
 QuickAdd-Node
@@ -509,11 +541,11 @@ QuickAdd-Node
 const QuickRemoveScriptShell = `
 #!/bin/sh
 #
-# {{ .Project }} - Tool to quick-remove OSX/Linux nodes
+# {{ .Project }} - {{ T "script.remove.unix" }}
 #
-# IMPORTANT! osquery will not be removed.
+# {{ T "script.remove.important" }}
 
-# Use TRACE=1 to debug script
+# {{ T "script.trace" }}
 if [ "$TRACE" = "1" ]; then
   set -o xtrace
 fi
@@ -569,7 +601,7 @@ whatOS() {
   log "_FLAGS=$_FLAGS"
   log "_CERT=$_CERT"
   log "_SERVICE=$_SERVICE"
-  log "IMPORTANT! osquery will not be removed."
+  log "{{ T "script.remove.important" }}"
 }
 
 stopOsquery() {
@@ -620,7 +652,7 @@ removeCert() {
 bye() {
   result=$?
   if [ "$result" != "0" ]; then
-    echo "[!] Failed to remove $_PROJECT node" >&2
+    echo "[!] {{ T "script.remove.failed" "$_PROJECT" }}" >&2
   fi
   exit $result
 }
@@ -632,8 +664,8 @@ removeSecret
 removeFlags
 removeCert
 
-log "Congratulations! The node has been removed from $_PROJECT"
-log "WARNING! $_SERVICE has been stopped and disabled."
+log "{{ T "script.remove.success" "$_PROJECT" }}"
+log "{{ T "script.remove.warning" "$_SERVICE" }}"
 
 # EOF
 `
@@ -641,9 +673,9 @@ log "WARNING! $_SERVICE has been stopped and disabled."
 // QuickRemoveScriptPowershell to keep the raw template for the quick remove powershell script
 const QuickRemoveScriptPowershell = `
 ##
-## {{ .Project }} - Tool to quick-remove Windows nodes
+## {{ .Project }} - {{ T "script.remove.windows" }}
 ##
-## IMPORTANT! osquery will not be removed.
+## {{ T "script.remove.important" }}
 
 ## Set-ExecutionPolicy Bypass -Scope Process -Force; iex ((New-Object System.Net.WebClient).DownloadString('https://host/path/test.ps1'))
 
@@ -675,7 +707,7 @@ function QuickRemove-Node
 {
   # Make sure we are admin
   if (-not (Test-IsAdmin)) {
-    Write-Host "[!] Please run this script with Admin privileges!" -foregroundcolor Red
+    Write-Host "[!] {{ T "script.admin" }}" -foregroundcolor Red
     Exit -1
   }
 
@@ -713,8 +745,8 @@ function QuickRemove-Node
     Remove-Item -ItemType "file" -Path $certFile
   }
 
-  Write-Host "Congratulations! The node has been removed from $projectName"
-  Write-Host "WARNING! $serviceName has been stopped and disabled."
+  Write-Host "{{ T "script.remove.success" "$projectName" }}"
+  Write-Host "{{ T "script.remove.warning" "$serviceName" }}"
 }
 
 QuickRemove-Node
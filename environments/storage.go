@@ -0,0 +1,755 @@
+package environments
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmpsec/osctrl/backend"
+	"github.com/jmpsec/osctrl/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Categories of the storage used by environments
+const (
+	StorageNodes   string = "nodes"
+	StorageStatus  string = "status"
+	StorageResults string = "results"
+	StorageQueries string = "queries"
+	StorageCarves  string = "carves"
+	StorageAudit   string = "audit"
+)
+
+// Actions of storage quotas, applied while the quota is exceeded
+const (
+	QuotaNotify         string = "notify"
+	QuotaBlockCarves    string = "block_carves"
+	QuotaPauseAlwaysLog string = "pause_always_log"
+)
+
+const (
+	// DefaultStorageInterval is the default interval to measure the storage of environments
+	DefaultStorageInterval = time.Hour
+	// DefaultStorageRetention is the default days to keep storage samples
+	DefaultStorageRetention int = 365
+	// DefaultStorageDays is the default days of storage samples returned
+	DefaultStorageDays int = 30
+	// DefaultCounterFlush is the default interval to store the storage counters of an instance
+	DefaultCounterFlush = 60 * time.Second
+	// QuotaReleasePercent is the usage, as percent of the quota, under which an exceeded quota is lifted
+	QuotaReleasePercent int64 = 90
+)
+
+// StorageCategories to list all the categories of storage, in the order they are shown
+var StorageCategories = []string{StorageNodes, StorageStatus, StorageResults, StorageQueries, StorageCarves, StorageAudit}
+
+// StorageTables to get the table measured for each category of storage
+var StorageTables = map[string]string{
+	StorageNodes:   "osquery_nodes",
+	StorageStatus:  "osquery_status_data",
+	StorageResults: "osquery_result_data",
+	StorageQueries: "osquery_query_data",
+	StorageCarves:  "carved_blocks",
+	StorageAudit:   "env_activities",
+}
+
+// QuotaActions to list all the actions of storage quotas
+var QuotaActions = []string{QuotaNotify, QuotaBlockCarves, QuotaPauseAlwaysLog}
+
+// StorageUsage to hold the rows and bytes used by an environment or a table
+type StorageUsage struct {
+	Rows  int64 `json:"rows"`
+	Bytes int64 `json:"bytes"`
+}
+
+// StorageSample to keep the daily storage used by one category of an environment
+type StorageSample struct {
+	ID            uint      `gorm:"primaryKey" json:"-"`
+	EnvironmentID uint      `gorm:"uniqueIndex:idx_storage_sample" json:"-"`
+	Day           time.Time `gorm:"uniqueIndex:idx_storage_sample" json:"day"`
+	Category      string    `gorm:"uniqueIndex:idx_storage_sample" json:"category"`
+	Rows          int64     `json:"rows"`
+	Bytes         int64     `json:"bytes"`
+	CreatedAt     time.Time `json:"-"`
+}
+
+// StorageCounter to keep the rows and bytes written by environment name for one category of storage.
+// Counters are only incremented, so they split tables between environments without counting their rows.
+type StorageCounter struct {
+	Environment string `gorm:"primaryKey"`
+	Category    string `gorm:"primaryKey"`
+	Rows        int64
+	Bytes       int64
+	UpdatedAt   time.Time
+}
+
+// StorageQuota to keep the soft quota of storage of an environment and if it is exceeded
+type StorageQuota struct {
+	EnvironmentID uint      `gorm:"primaryKey;autoIncrement:false" json:"-"`
+	MaxBytes      int64     `json:"max_bytes"`
+	Actions       string    `json:"actions"`
+	Exceeded      bool      `json:"exceeded"`
+	Since         time.Time `json:"since"`
+	UsedBytes     int64     `json:"used_bytes"`
+	UpdatedBy     string    `json:"updated_by"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// StorageReport to describe the storage of an environment, with the latest usage by category and the daily samples
+type StorageReport struct {
+	Environment string                  `json:"environment"`
+	Day         time.Time               `json:"day"`
+	Total       StorageUsage            `json:"total"`
+	Categories  map[string]StorageUsage `json:"categories"`
+	Quota       StorageQuota            `json:"quota"`
+	Active      []string                `json:"active_actions"`
+	History     []StorageSample         `json:"history"`
+}
+
+// StorageSource to get how the rows of one category of storage are split by environment ID
+type StorageSource func(envs []TLSEnvironment) (map[uint]int64, error)
+
+// Size to get the bytes of a usage for humans
+func (u StorageUsage) Size() string {
+	return utils.BytesReceivedConversion(int(u.Bytes))
+}
+
+// MaxSize to get the bytes of a quota for humans
+func (q StorageQuota) MaxSize() string {
+	return utils.BytesReceivedConversion(int(q.MaxBytes))
+}
+
+// UsedSize to get the bytes used by the environment of a quota for humans
+func (q StorageQuota) UsedSize() string {
+	return utils.BytesReceivedConversion(int(q.UsedBytes))
+}
+
+// ActionList to get the actions of a quota
+func (q StorageQuota) ActionList() []string {
+	var res []string
+	for _, a := range strings.Split(q.Actions, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			res = append(res, a)
+		}
+	}
+	return res
+}
+
+// HasAction to check if a quota has an action, exceeded or not
+func (q StorageQuota) HasAction(action string) bool {
+	for _, a := range q.ActionList() {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// Active to check if an action of a quota applies now, because the quota is exceeded
+func (q StorageQuota) Active(action string) bool {
+	return q.Exceeded && q.HasAction(action)
+}
+
+// ActiveActions to get the actions of a quota that apply now
+func (q StorageQuota) ActiveActions() []string {
+	if !q.Exceeded {
+		return nil
+	}
+	return q.ActionList()
+}
+
+// Percent to get the usage of a quota as percent, 0 without quota
+func (q StorageQuota) Percent() int64 {
+	if q.MaxBytes <= 0 {
+		return 0
+	}
+	return q.UsedBytes * 100 / q.MaxBytes
+}
+
+// Evaluate to get if a quota is exceeded with the bytes used. Once exceeded, it is lifted when the usage
+// drops under QuotaReleasePercent of the quota, so actions do not flap around the limit.
+func (q StorageQuota) Evaluate(used int64) bool {
+	if q.MaxBytes <= 0 {
+		return false
+	}
+	if used >= q.MaxBytes {
+		return true
+	}
+	if q.Exceeded {
+		return float64(used) >= float64(q.MaxBytes)*float64(QuotaReleasePercent)/100
+	}
+	return false
+}
+
+// ParseQuotaActions to validate a comma separated list of quota actions, returns them normalized
+func ParseQuotaActions(list string) (string, error) {
+	var res []string
+	seen := make(map[string]bool)
+	for _, a := range strings.Split(list, ",") {
+		a = strings.ToLower(strings.TrimSpace(a))
+		if a == "" || seen[a] {
+			continue
+		}
+		valid := false
+		for _, v := range QuotaActions {
+			if a == v {
+				valid = true
+			}
+		}
+		if !valid {
+			return "", fmt.Errorf("invalid quota action %s", a)
+		}
+		seen[a] = true
+		res = append(res, a)
+	}
+	return strings.Join(res, ","), nil
+}
+
+// ParseStorageSize to convert sizes like 500MB or 2TB into bytes, plain numbers are bytes
+func ParseStorageSize(value string) (int64, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	if value == "" {
+		return 0, nil
+	}
+	units := []struct {
+		suffix string
+		size   float64
+	}{
+		{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1},
+	}
+	multiplier := float64(1)
+	for _, u := range units {
+		if strings.HasSuffix(value, u.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, u.suffix))
+			multiplier = u.size
+			break
+		}
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number < 0 || math.IsInf(number, 0) {
+		return 0, fmt.Errorf("invalid size %s", value)
+	}
+	return int64(number * multiplier), nil
+}
+
+// StorageDay to get the day of a storage sample, as midnight in UTC
+func StorageDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// ApportionStorage to split the usage of a table between environments, proportionally to their weights
+func ApportionStorage(total StorageUsage, weights map[uint]int64) map[uint]StorageUsage {
+	res := make(map[uint]StorageUsage)
+	var sum int64
+	for _, w := range weights {
+		if w > 0 {
+			sum += w
+		}
+	}
+	if sum == 0 {
+		return res
+	}
+	for envid, w := range weights {
+		if w <= 0 {
+			continue
+		}
+		share := float64(w) / float64(sum)
+		res[envid] = StorageUsage{
+			Rows:  int64(math.Round(float64(total.Rows) * share)),
+			Bytes: int64(math.Round(float64(total.Bytes) * share)),
+		}
+	}
+	return res
+}
+
+// StorageTotal to add up the usage of all the categories
+func StorageTotal(usage map[string]StorageUsage) StorageUsage {
+	var total StorageUsage
+	for _, u := range usage {
+		total.Rows += u.Rows
+		total.Bytes += u.Bytes
+	}
+	return total
+}
+
+// DailyStorage to add up the samples of all the categories by day, newest first
+func DailyStorage(samples []StorageSample) []StorageSample {
+	var res []StorageSample
+	index := make(map[time.Time]int)
+	for _, s := range samples {
+		i, ok := index[s.Day]
+		if !ok {
+			i = len(res)
+			index[s.Day] = i
+			res = append(res, StorageSample{EnvironmentID: s.EnvironmentID, Day: s.Day, Category: "total"})
+		}
+		res[i].Rows += s.Rows
+		res[i].Bytes += s.Bytes
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Day.After(res[j].Day) })
+	return res
+}
+
+// Size to get the bytes of a sample for humans
+func (s StorageSample) Size() string {
+	return utils.BytesReceivedConversion(int(s.Bytes))
+}
+
+// TableUsage to get the estimated rows and the size with indexes of a table from the statistics of the
+// database, so tables are not scanned. Tables that do not exist use nothing.
+func (environment *Environment) TableUsage(table string) (StorageUsage, error) {
+	var usage StorageUsage
+	query := `SELECT GREATEST(c.reltuples, 0)::bigint AS rows, pg_total_relation_size(c.oid) AS bytes
+		FROM pg_class c WHERE c.oid = to_regclass(?)`
+	if err := environment.read().Raw(query, table).Scan(&usage).Error; err != nil {
+		return usage, fmt.Errorf("TableUsage %s %v", table, err)
+	}
+	return usage, nil
+}
+
+// CounterSource to split a category of storage by the bytes written to it by each environment
+func (environment *Environment) CounterSource(category string) StorageSource {
+	return func(envs []TLSEnvironment) (map[uint]int64, error) {
+		weights := make(map[uint]int64)
+		var counters []StorageCounter
+		if err := environment.read().Where("category = ?", category).Find(&counters).Error; err != nil {
+			return weights, fmt.Errorf("Find counters %v", err)
+		}
+		byName := make(map[string]uint, len(envs))
+		for _, e := range envs {
+			byName[e.Name] = e.ID
+			byName[e.UUID] = e.ID
+		}
+		for _, c := range counters {
+			if envid, ok := byName[c.Environment]; ok {
+				weights[envid] += c.Bytes
+			}
+		}
+		return weights, nil
+	}
+}
+
+// ActivitySource to split the activity of environments by their entries, counted with the index by environment
+func (environment *Environment) ActivitySource(envs []TLSEnvironment) (map[uint]int64, error) {
+	weights := make(map[uint]int64)
+	type envCount struct {
+		EnvironmentID uint
+		Entries       int64
+	}
+	var counts []envCount
+	if err := environment.read().Model(&EnvActivity{}).Select("environment_id, count(*) as entries").Group("environment_id").Scan(&counts).Error; err != nil {
+		return weights, fmt.Errorf("Group activity %v", err)
+	}
+	for _, c := range counts {
+		weights[c.EnvironmentID] = c.Entries
+	}
+	return weights, nil
+}
+
+// MeasureStorage to get the storage used by each environment, by category. Each table is measured once
+// and split between environments with the source of its category.
+func (environment *Environment) MeasureStorage(envs []TLSEnvironment, sources map[string]StorageSource) (map[uint]map[string]StorageUsage, error) {
+	res := make(map[uint]map[string]StorageUsage)
+	for _, e := range envs {
+		res[e.ID] = make(map[string]StorageUsage)
+	}
+	for _, category := range StorageCategories {
+		source, ok := sources[category]
+		if !ok {
+			continue
+		}
+		total, err := environment.TableUsage(StorageTables[category])
+		if err != nil {
+			return res, err
+		}
+		weights, err := source(envs)
+		if err != nil {
+			return res, fmt.Errorf("source %s %v", category, err)
+		}
+		for envid, usage := range ApportionStorage(total, weights) {
+			if _, ok := res[envid]; ok {
+				res[envid][category] = usage
+			}
+		}
+	}
+	return res, nil
+}
+
+// SaveStorage to record the storage of an environment for one day. Running it again for the same day
+// replaces the samples, so it does not duplicate them.
+func (environment *Environment) SaveStorage(envid uint, day time.Time, usage map[string]StorageUsage) error {
+	day = StorageDay(day)
+	samples := make([]StorageSample, 0, len(StorageCategories))
+	for _, category := range StorageCategories {
+		u := usage[category]
+		samples = append(samples, StorageSample{EnvironmentID: envid, Day: day, Category: category, Rows: u.Rows, Bytes: u.Bytes})
+	}
+	err := environment.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "environment_id"}, {Name: "day"}, {Name: "category"}},
+		DoUpdates: clause.AssignmentColumns([]string{"rows", "bytes"}),
+	}).Create(&samples).Error
+	if err != nil {
+		return fmt.Errorf("Create %v", err)
+	}
+	return nil
+}
+
+// StorageHistory to get the storage samples of an environment between two days, oldest first
+func (environment *Environment) StorageHistory(envid uint, from, to time.Time) ([]StorageSample, error) {
+	var samples []StorageSample
+	if err := environment.read().Where("environment_id = ? AND day >= ? AND day <= ?", envid, StorageDay(from), StorageDay(to)).Order("day, category").Find(&samples).Error; err != nil {
+		return samples, fmt.Errorf("Find %v", err)
+	}
+	return samples, nil
+}
+
+// LatestStorage to get the storage of the latest samples of an environment by category, with their day
+func (environment *Environment) LatestStorage(envid uint) (map[string]StorageUsage, time.Time, error) {
+	usage := make(map[string]StorageUsage)
+	var latest StorageSample
+	if err := environment.read().Where("environment_id = ?", envid).Order("day DESC").Limit(1).Find(&latest).Error; err != nil {
+		return usage, time.Time{}, fmt.Errorf("Find latest %v", err)
+	}
+	if latest.ID == 0 {
+		return usage, time.Time{}, nil
+	}
+	var samples []StorageSample
+	if err := environment.read().Where("environment_id = ? AND day = ?", envid, latest.Day).Find(&samples).Error; err != nil {
+		return usage, latest.Day, fmt.Errorf("Find %v", err)
+	}
+	for _, s := range samples {
+		usage[s.Category] = StorageUsage{Rows: s.Rows, Bytes: s.Bytes}
+	}
+	return usage, latest.Day, nil
+}
+
+// StorageTotals to get the total storage of the latest samples of all environments, by environment ID
+func (environment *Environment) StorageTotals() (map[uint]StorageUsage, error) {
+	res := make(map[uint]StorageUsage)
+	var latest StorageSample
+	if err := environment.read().Order("day DESC").Limit(1).Find(&latest).Error; err != nil {
+		return res, fmt.Errorf("Find latest %v", err)
+	}
+	if latest.ID == 0 {
+		return res, nil
+	}
+	var samples []StorageSample
+	if err := environment.read().Where("day = ?", latest.Day).Find(&samples).Error; err != nil {
+		return res, fmt.Errorf("Find %v", err)
+	}
+	for _, s := range samples {
+		u := res[s.EnvironmentID]
+		u.Rows += s.Rows
+		u.Bytes += s.Bytes
+		res[s.EnvironmentID] = u
+	}
+	return res, nil
+}
+
+// StorageReport to get the latest storage of an environment with its quota and the samples since a day
+func (environment *Environment) StorageReport(env TLSEnvironment, from, to time.Time) (StorageReport, error) {
+	report := StorageReport{Environment: env.Name}
+	usage, day, err := environment.LatestStorage(env.ID)
+	if err != nil {
+		return report, err
+	}
+	report.Day = day
+	report.Categories = usage
+	report.Total = StorageTotal(usage)
+	if report.Quota, err = environment.GetStorageQuota(env.ID); err != nil {
+		return report, err
+	}
+	report.Active = report.Quota.ActiveActions()
+	if report.History, err = environment.StorageHistory(env.ID, from, to); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// PruneStorage to delete storage samples older than the retention in days, 0 keeps them forever
+func (environment *Environment) PruneStorage(now time.Time, retention int) error {
+	if retention <= 0 {
+		return nil
+	}
+	if err := environment.DB.Where("day < ?", StorageDay(now).AddDate(0, 0, -retention)).Delete(&StorageSample{}).Error; err != nil {
+		return fmt.Errorf("Delete %v", err)
+	}
+	return nil
+}
+
+// StorageQuotas to get the storage quotas of all environments by environment ID
+func (environment *Environment) StorageQuotas() (map[uint]StorageQuota, error) {
+	var quotas []StorageQuota
+	res := make(map[uint]StorageQuota)
+	if err := environment.read().Find(&quotas).Error; err != nil {
+		return res, err
+	}
+	for _, q := range quotas {
+		res[q.EnvironmentID] = q
+	}
+	return res, nil
+}
+
+// GetStorageQuota to get the storage quota of one environment, without quota if it was never set
+func (environment *Environment) GetStorageQuota(envid uint) (StorageQuota, error) {
+	var quota StorageQuota
+	if err := environment.read().Where("environment_id = ?", envid).Find(&quota).Error; err != nil {
+		return StorageQuota{EnvironmentID: envid}, fmt.Errorf("GetStorageQuota %v", err)
+	}
+	quota.EnvironmentID = envid
+	return quota, nil
+}
+
+// SetStorageQuota to set the quota in bytes and the actions of an environment, 0 removes the quota.
+// The quota is evaluated again with the latest usage, so lowering it applies the actions right away.
+func (environment *Environment) SetStorageQuota(envid uint, maxBytes int64, actions, user string) (StorageQuota, error) {
+	if maxBytes < 0 {
+		return StorageQuota{}, fmt.Errorf("invalid quota %d", maxBytes)
+	}
+	actions, err := ParseQuotaActions(actions)
+	if err != nil {
+		return StorageQuota{}, err
+	}
+	quota, err := environment.GetStorageQuota(envid)
+	if err != nil {
+		return quota, err
+	}
+	usage, _, err := environment.LatestStorage(envid)
+	if err != nil {
+		return quota, err
+	}
+	quota.MaxBytes = maxBytes
+	quota.Actions = actions
+	quota.UpdatedBy = user
+	quota.UsedBytes = StorageTotal(usage).Bytes
+	exceeded := quota.Evaluate(quota.UsedBytes)
+	if exceeded != quota.Exceeded {
+		quota.Since = time.Now()
+	}
+	quota.Exceeded = exceeded
+	if err := environment.saveQuota(quota); err != nil {
+		return quota, err
+	}
+	return quota, nil
+}
+
+// Helper to save the quota of an environment
+func (environment *Environment) saveQuota(quota StorageQuota) error {
+	quota.UpdatedAt = time.Now()
+	if err := environment.DB.Clauses(clause.OnConflict{UpdateAll: true}).Create(&quota).Error; err != nil {
+		return fmt.Errorf("saveQuota %v", err)
+	}
+	return nil
+}
+
+// CheckQuota to evaluate the quota of an environment with the bytes used, returns the quota and if it
+// changed between exceeded and lifted
+func (environment *Environment) CheckQuota(envid uint, used int64) (StorageQuota, bool, error) {
+	quota, err := environment.GetStorageQuota(envid)
+	if err != nil {
+		return quota, false, err
+	}
+	if quota.MaxBytes <= 0 && !quota.Exceeded {
+		return quota, false, nil
+	}
+	exceeded := quota.Evaluate(used)
+	changed := exceeded != quota.Exceeded
+	quota.UsedBytes = used
+	if changed {
+		quota.Exceeded = exceeded
+		quota.Since = time.Now()
+	}
+	if err := environment.saveQuota(quota); err != nil {
+		return quota, changed, err
+	}
+	return quota, changed, nil
+}
+
+// RunStorage to measure the storage of all environments for the current day, check their quotas and prune old
+// samples. Only the instance holding the leader lock does it, the rest skip it. Quotas that are exceeded or
+// lifted are passed to onChange.
+func (environment *Environment) RunStorage(leader *backend.LeaderLock, sources map[string]StorageSource, retention int, now time.Time, onChange func(TLSEnvironment, StorageQuota)) {
+	if leader != nil {
+		isLeader, err := leader.Acquire()
+		if err != nil {
+			log.Printf("error acquiring leader lock %v", err)
+			return
+		}
+		if !isLeader {
+			return
+		}
+	}
+	envs, err := environment.All()
+	if err != nil {
+		log.Printf("error getting environments for storage %v", err)
+		return
+	}
+	usage, err := environment.MeasureStorage(envs, sources)
+	if err != nil {
+		log.Printf("error measuring storage %v", err)
+		return
+	}
+	for _, env := range envs {
+		if err := environment.SaveStorage(env.ID, now, usage[env.ID]); err != nil {
+			log.Printf("error saving storage for environment %s %v", env.Name, err)
+			continue
+		}
+		quota, changed, err := environment.CheckQuota(env.ID, StorageTotal(usage[env.ID]).Bytes)
+		if err != nil {
+			log.Printf("error checking storage quota for environment %s %v", env.Name, err)
+			continue
+		}
+		if changed && onChange != nil {
+			onChange(env, quota)
+		}
+	}
+	if err := environment.PruneStorage(now, retention); err != nil {
+		log.Printf("error pruning storage samples %v", err)
+	}
+}
+
+// StartStorage to measure the storage of environments periodically, the latest measurement of each day is kept
+func (environment *Environment) StartStorage(ctx context.Context, leader *backend.LeaderLock, interval time.Duration, sources map[string]StorageSource, onChange func(TLSEnvironment, StorageQuota)) {
+	if interval <= 0 {
+		interval = DefaultStorageInterval
+	}
+	go func() {
+		environment.RunStorage(leader, sources, DefaultStorageRetention, time.Now(), onChange)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				environment.RunStorage(leader, sources, DefaultStorageRetention, time.Now(), onChange)
+			}
+		}
+	}()
+}
+
+// NotifyStorageQuota to send a quota exceeded or lifted as JSON to a webhook, errors are only logged
+func NotifyStorageQuota(webhook string, env TLSEnvironment, quota StorageQuota) {
+	if webhook == "" {
+		return
+	}
+	event := "storage_quota_lifted"
+	if quota.Exceeded {
+		event = "storage_quota_exceeded"
+	}
+	payload, err := json.Marshal(map[string]interface{}{
+		"event":       event,
+		"environment": env.Name,
+		"max_bytes":   quota.MaxBytes,
+		"used_bytes":  quota.UsedBytes,
+		"actions":     quota.ActionList(),
+		"since":       quota.Since,
+	})
+	if err != nil {
+		log.Printf("error serializing storage quota %v", err)
+		return
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(webhook, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		log.Printf("error notifying storage quota %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("error notifying storage quota, webhook returned %d", resp.StatusCode)
+	}
+}
+
+type counterKey struct {
+	environment string
+	category    string
+}
+
+// StorageCounters to count in memory the rows and bytes written by an instance, stored periodically
+type StorageCounters struct {
+	DB     *gorm.DB
+	mutex  sync.Mutex
+	counts map[counterKey]StorageUsage
+}
+
+// CreateStorageCounters to initialize the storage counters of an instance
+func CreateStorageCounters(backend *gorm.DB) *StorageCounters {
+	return &StorageCounters{
+		DB:     backend,
+		counts: make(map[counterKey]StorageUsage),
+	}
+}
+
+// Add to count rows and bytes written for an environment in one category of storage
+func (c *StorageCounters) Add(environment, category string, rows, bytes int64) {
+	if c == nil || (rows == 0 && bytes == 0) {
+		return
+	}
+	c.mutex.Lock()
+	k := counterKey{environment: environment, category: category}
+	u := c.counts[k]
+	u.Rows += rows
+	u.Bytes += bytes
+	c.counts[k] = u
+	c.mutex.Unlock()
+}
+
+// Flush to add the current counts to the stored counters
+func (c *StorageCounters) Flush() error {
+	c.mutex.Lock()
+	counts := c.counts
+	c.counts = make(map[counterKey]StorageUsage)
+	c.mutex.Unlock()
+	now := time.Now()
+	for k, v := range counts {
+		counter := StorageCounter{Environment: k.environment, Category: k.category, Rows: v.Rows, Bytes: v.Bytes, UpdatedAt: now}
+		err := c.DB.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "environment"}, {Name: "category"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"rows":       gorm.Expr("storage_counters.rows + excluded.rows"),
+				"bytes":      gorm.Expr("storage_counters.bytes + excluded.bytes"),
+				"updated_at": now,
+			}),
+		}).Create(&counter).Error
+		if err != nil {
+			return fmt.Errorf("Create %v", err)
+		}
+	}
+	return nil
+}
+
+// Start to flush the counters periodically until the context is done
+func (c *StorageCounters) Start(ctx context.Context, interval time.Duration) {
+	if c == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				if err := c.Flush(); err != nil {
+					log.Printf("error flushing storage counters %v", err)
+				}
+				return
+			case <-ticker.C:
+				if err := c.Flush(); err != nil {
+					log.Printf("error flushing storage counters %v", err)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,100 @@
+package environments
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApportionStorage(t *testing.T) {
+	res := ApportionStorage(StorageUsage{Rows: 1000, Bytes: 4000}, map[uint]int64{1: 3, 2: 1, 3: 0})
+	assert.Equal(t, StorageUsage{Rows: 750, Bytes: 3000}, res[1])
+	assert.Equal(t, StorageUsage{Rows: 250, Bytes: 1000}, res[2])
+	_, ok := res[3]
+	assert.False(t, ok)
+	assert.Empty(t, ApportionStorage(StorageUsage{Rows: 10, Bytes: 10}, map[uint]int64{1: 0}))
+}
+
+func TestStorageTotal(t *testing.T) {
+	total := StorageTotal(map[string]StorageUsage{
+		StorageNodes:  {Rows: 10, Bytes: 100},
+		StorageStatus: {Rows: 5, Bytes: 50},
+	})
+	assert.Equal(t, StorageUsage{Rows: 15, Bytes: 150}, total)
+}
+
+func TestQuotaEvaluate(t *testing.T) {
+	q := StorageQuota{MaxBytes: 1000}
+	assert.False(t, q.Evaluate(950))
+	assert.True(t, q.Evaluate(1000))
+	q.Exceeded = true
+	assert.True(t, q.Evaluate(950))
+	assert.True(t, q.Evaluate(900))
+	assert.False(t, q.Evaluate(899))
+	assert.False(t, StorageQuota{Exceeded: true}.Evaluate(5000))
+}
+
+func TestQuotaActions(t *testing.T) {
+	q := StorageQuota{MaxBytes: 1000, UsedBytes: 500, Actions: "notify,block_carves"}
+	assert.Equal(t, []string{QuotaNotify, QuotaBlockCarves}, q.ActionList())
+	assert.True(t, q.HasAction(QuotaBlockCarves))
+	assert.False(t, q.Active(QuotaBlockCarves))
+	assert.Nil(t, q.ActiveActions())
+	assert.Equal(t, int64(50), q.Percent())
+	q.Exceeded = true
+	assert.True(t, q.Active(QuotaBlockCarves))
+	assert.False(t, q.Active(QuotaPauseAlwaysLog))
+	assert.Equal(t, []string{QuotaNotify, QuotaBlockCarves}, q.ActiveActions())
+}
+
+func TestParseQuotaActions(t *testing.T) {
+	actions, err := ParseQuotaActions(" Notify, block_carves,notify,, ")
+	assert.NoError(t, err)
+	assert.Equal(t, "notify,block_carves", actions)
+	actions, err = ParseQuotaActions("")
+	assert.NoError(t, err)
+	assert.Equal(t, "", actions)
+	_, err = ParseQuotaActions("notify,delete_nodes")
+	assert.Error(t, err)
+}
+
+func TestParseStorageSize(t *testing.T) {
+	tests := map[string]int64{
+		"":      0,
+		"1024":  1024,
+		"10KB":  10 << 10,
+		"500mb": 500 << 20,
+		"1.5GB": 3 << 29,
+		"2 TB":  2 << 40,
+	}
+	for value, expected := range tests {
+		size, err := ParseStorageSize(value)
+		assert.NoError(t, err, value)
+		assert.Equal(t, expected, size, value)
+	}
+	_, err := ParseStorageSize("lots")
+	assert.Error(t, err)
+	_, err = ParseStorageSize("-5GB")
+	assert.Error(t, err)
+}
+
+func TestDailyStorage(t *testing.T) {
+	day1 := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	day2 := day1.AddDate(0, 0, 1)
+	daily := DailyStorage([]StorageSample{
+		{Day: day1, Category: StorageNodes, Rows: 1, Bytes: 10},
+		{Day: day1, Category: StorageStatus, Rows: 2, Bytes: 20},
+		{Day: day2, Category: StorageNodes, Rows: 3, Bytes: 30},
+	})
+	assert.Len(t, daily, 2)
+	assert.Equal(t, day2, daily[0].Day)
+	assert.Equal(t, int64(30), daily[0].Bytes)
+	assert.Equal(t, int64(3), daily[1].Rows)
+	assert.Equal(t, int64(30), daily[1].Bytes)
+}
+
+func TestStorageDay(t *testing.T) {
+	loc := time.FixedZone("test", -5*3600)
+	assert.Equal(t, time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC), StorageDay(time.Date(2024, 3, 1, 22, 0, 0, 0, loc)))
+}
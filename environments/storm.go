@@ -0,0 +1,113 @@
+package environments
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// DefaultStormFactor is how many times the intervals are increased for nodes in backoff
+	DefaultStormFactor int = 4
+	// DefaultStormPercent is the percentage of nodes that get backoff hints during a storm
+	DefaultStormPercent int = 50
+	// DefaultStormCooldown is the time in seconds the rate must stay under the threshold to end a storm
+	DefaultStormCooldown int = 300
+)
+
+// StormState to keep the check-in storm protection state of an environment, written by the TLS service
+type StormState struct {
+	EnvironmentID uint `gorm:"primaryKey;autoIncrement:false"`
+	Active        bool
+	Rate          int
+	Since         time.Time
+	UpdatedAt     time.Time
+}
+
+// StormBackoff to describe the backoff hints served to nodes during a storm
+type StormBackoff struct {
+	Factor         int
+	ConfigInterval int
+	QueryInterval  int
+}
+
+// UpdateStormThreshold to update the requests per minute that trigger storm protection, 0 disables it
+func (environment *Environment) UpdateStormThreshold(idEnv string, threshold int) error {
+	if threshold < 0 {
+		return fmt.Errorf("invalid storm threshold %d", threshold)
+	}
+	if err := environment.DB.Model(&TLSEnvironment{}).Where("name = ? OR uuid = ?", idEnv, idEnv).Update("storm_threshold", threshold).Error; err != nil {
+		return fmt.Errorf("UpdateStormThreshold %v", err)
+	}
+	return nil
+}
+
+// SetStormState to save the storm protection state of an environment
+func (environment *Environment) SetStormState(state StormState) error {
+	state.UpdatedAt = time.Now()
+	if err := environment.DB.Clauses(clause.OnConflict{UpdateAll: true}).Create(&state).Error; err != nil {
+		return fmt.Errorf("SetStormState %v", err)
+	}
+	return nil
+}
+
+// StormStates to get the storm protection state of all environments by environment ID
+func (environment *Environment) StormStates() (map[uint]StormState, error) {
+	var states []StormState
+	res := make(map[uint]StormState)
+	if err := environment.read().Find(&states).Error; err != nil {
+		return res, err
+	}
+	for _, s := range states {
+		res[s.EnvironmentID] = s
+	}
+	return res, nil
+}
+
+// GetStormState to get the storm protection state of one environment, inactive if it was never recorded
+func (environment *Environment) GetStormState(envid uint) (StormState, error) {
+	states, err := environment.StormStates()
+	if err != nil {
+		return StormState{EnvironmentID: envid}, err
+	}
+	if s, ok := states[envid]; ok {
+		return s, nil
+	}
+	return StormState{EnvironmentID: envid}, nil
+}
+
+// Backoff to calculate the intervals served to nodes in backoff for an environment
+func (env TLSEnvironment) Backoff(factor int) StormBackoff {
+	if factor < 1 {
+		factor = DefaultStormFactor
+	}
+	config := env.ConfigInterval
+	if config <= 0 {
+		config = DefaultConfigInterval
+	}
+	query := env.QueryInterval
+	if query <= 0 {
+		query = DefaultQueryInterval
+	}
+	return StormBackoff{
+		Factor:         factor,
+		ConfigInterval: config * factor,
+		QueryInterval:  query * factor,
+	}
+}
+
+// ApplyStormBackoff to increase config_refresh and distributed_interval in the options of a serialized
+// configuration. Values already higher than the backoff ones are kept.
+func ApplyStormBackoff(configuration []byte, backoff StormBackoff) ([]byte, error) {
+	return updateOptions(configuration, func(options map[string]interface{}) {
+		raise := func(name string, value int) {
+			if current, ok := options[name].(float64); ok && int(current) >= value {
+				return
+			}
+			options[name] = value
+		}
+		raise("config_refresh", backoff.ConfigInterval)
+		raise("distributed_interval", backoff.QueryInterval)
+	})
+}
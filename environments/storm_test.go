@@ -0,0 +1,34 @@
+package environments
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff(t *testing.T) {
+	env := TLSEnvironment{ConfigInterval: 300, QueryInterval: 60}
+	assert.Equal(t, StormBackoff{Factor: 4, ConfigInterval: 1200, QueryInterval: 240}, env.Backoff(4))
+	assert.Equal(t, StormBackoff{Factor: DefaultStormFactor, ConfigInterval: 300 * DefaultStormFactor, QueryInterval: 60 * DefaultStormFactor}, env.Backoff(0))
+}
+
+func TestApplyStormBackoff(t *testing.T) {
+	conf := `{"options": {"host_identifier": "uuid", "config_refresh": 5000}, "schedule": {"uptime": {"query": "SELECT * FROM uptime;", "interval": 3600}}}`
+	res, err := ApplyStormBackoff([]byte(conf), StormBackoff{Factor: 4, ConfigInterval: 1200, QueryInterval: 240})
+	assert.NoError(t, err)
+	var parsed struct {
+		Options  map[string]interface{} `json:"options"`
+		Schedule ScheduleConf           `json:"schedule"`
+	}
+	assert.NoError(t, json.Unmarshal(res, &parsed))
+	assert.Equal(t, "uuid", parsed.Options["host_identifier"])
+	assert.Equal(t, float64(5000), parsed.Options["config_refresh"])
+	assert.Equal(t, float64(240), parsed.Options["distributed_interval"])
+	assert.Equal(t, json.Number("3600"), parsed.Schedule["uptime"].Interval)
+}
+
+func TestApplyStormBackoffInvalid(t *testing.T) {
+	_, err := ApplyStormBackoff([]byte("not json"), StormBackoff{})
+	assert.Error(t, err)
+}
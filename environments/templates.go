@@ -0,0 +1,480 @@
+package environments
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// TemplateSettings is the section of templates with the values of an environment that are not osquery configuration
+const TemplateSettings string = "settings"
+
+// TemplateSections to list the parts of a template, in display order
+var TemplateSections = []string{
+	ActivityOptions,
+	ActivitySchedule,
+	ActivityPacks,
+	ActivityDecorators,
+	ActivityATC,
+	ActivityFlags,
+	TemplateSettings,
+}
+
+// TemplateConfig to hold all the configuration of an environment that a template sets.
+// Flags are the extra flags added to the flags generated for each environment.
+type TemplateConfig struct {
+	Hostname         string
+	Type             string
+	Icon             string
+	DebugHTTP        bool
+	Options          string
+	Schedule         string
+	Packs            string
+	Decorators       string
+	ATC              string
+	Flags            string
+	ConfigTLS        bool
+	ConfigInterval   int
+	LoggingTLS       bool
+	LogInterval      int
+	QueryTLS         bool
+	QueryInterval    int
+	CarvesTLS        bool
+	CarveScanPolicy  string
+	StormThreshold   int
+	Locale           string
+	AllowedPlatforms string
+}
+
+// EnvTemplate to hold a template to create environments, it can not be used by nodes
+type EnvTemplate struct {
+	gorm.Model
+	Name           string `gorm:"index"`
+	Description    string
+	Version        int
+	Author         string
+	TemplateConfig `gorm:"embedded"`
+}
+
+// EnvTemplateVersion to keep an immutable version of a template, to compare environments with what they came from
+type EnvTemplateVersion struct {
+	gorm.Model
+	TemplateID     uint `gorm:"index"`
+	Version        int
+	Author         string
+	Note           string
+	TemplateConfig `gorm:"embedded"`
+}
+
+// TemplateDiff to hold the differences between an environment and a template
+type TemplateDiff struct {
+	Environment string        `json:"environment"`
+	Version     int           `json:"template_version"`
+	Changed     bool          `json:"changed"`
+	Diffs       []VersionDiff `json:"diffs"`
+}
+
+// Helper to get the lines of a flags string, without empty lines
+func flagLines(flags string) []string {
+	var lines []string
+	for _, l := range strings.Split(flags, "\n") {
+		if l = strings.TrimSpace(l); l != "" {
+			lines = append(lines, l)
+		}
+	}
+	return lines
+}
+
+// ExtraFlags to get the flags of an environment that are not generated, so they can be kept in a template
+func (environment *Environment) ExtraFlags(env TLSEnvironment) string {
+	generated, _ := environment.GenerateFlags(env, "", "")
+	known := make(map[string]bool)
+	for _, l := range flagLines(generated) {
+		known[l] = true
+	}
+	var extra []string
+	for _, l := range flagLines(env.Flags) {
+		if !known[l] {
+			extra = append(extra, l)
+		}
+	}
+	return strings.Join(extra, "\n")
+}
+
+// TemplateOf to get the configuration of an environment that can be kept in a template
+func (environment *Environment) TemplateOf(env TLSEnvironment) TemplateConfig {
+	return TemplateConfig{
+		Hostname:         env.Hostname,
+		Type:             env.Type,
+		Icon:             env.Icon,
+		DebugHTTP:        env.DebugHTTP,
+		Options:          env.Options,
+		Schedule:         env.Schedule,
+		Packs:            env.Packs,
+		Decorators:       env.Decorators,
+		ATC:              env.ATC,
+		Flags:            environment.ExtraFlags(env),
+		ConfigTLS:        env.ConfigTLS,
+		ConfigInterval:   env.ConfigInterval,
+		LoggingTLS:       env.LoggingTLS,
+		LogInterval:      env.LogInterval,
+		QueryTLS:         env.QueryTLS,
+		QueryInterval:    env.QueryInterval,
+		CarvesTLS:        env.CarvesTLS,
+		CarveScanPolicy:  env.CarveScanPolicy,
+		StormThreshold:   env.StormThreshold,
+		Locale:           env.Locale,
+		AllowedPlatforms: env.AllowedPlatforms,
+	}
+}
+
+// ApplyTemplate to set the configuration of a template in an environment, flags are generated again for the
+// environment with the extra flags of the template. Secrets, paths and the name of the environment are kept.
+func (environment *Environment) ApplyTemplate(env *TLSEnvironment, c TemplateConfig) {
+	if c.Hostname != "" {
+		env.Hostname = c.Hostname
+	}
+	env.Type = c.Type
+	env.Icon = c.Icon
+	env.DebugHTTP = c.DebugHTTP
+	env.Options = c.Options
+	env.Schedule = c.Schedule
+	env.Packs = c.Packs
+	env.Decorators = c.Decorators
+	env.ATC = c.ATC
+	env.ConfigTLS = c.ConfigTLS
+	env.ConfigInterval = c.ConfigInterval
+	env.LoggingTLS = c.LoggingTLS
+	env.LogInterval = c.LogInterval
+	env.QueryTLS = c.QueryTLS
+	env.QueryInterval = c.QueryInterval
+	env.CarvesTLS = c.CarvesTLS
+	env.CarveScanPolicy = c.CarveScanPolicy
+	env.StormThreshold = c.StormThreshold
+	env.Locale = c.Locale
+	env.AllowedPlatforms = c.AllowedPlatforms
+	flags, _ := environment.GenerateFlags(*env, "", "")
+	if extra := strings.TrimSpace(c.Flags); extra != "" {
+		flags = strings.TrimRight(flags, "\n") + "\n" + extra + "\n"
+	}
+	env.Flags = flags
+}
+
+// SettingsText to get the settings of a template as text, one value per line
+func (c TemplateConfig) SettingsText() string {
+	values := [][]string{
+		{"hostname", c.Hostname},
+		{"type", c.Type},
+		{"icon", c.Icon},
+		{"debug_http", strconv.FormatBool(c.DebugHTTP)},
+		{"config_tls", strconv.FormatBool(c.ConfigTLS)},
+		{"config_interval", strconv.Itoa(c.ConfigInterval)},
+		{"logging_tls", strconv.FormatBool(c.LoggingTLS)},
+		{"log_interval", strconv.Itoa(c.LogInterval)},
+		{"query_tls", strconv.FormatBool(c.QueryTLS)},
+		{"query_interval", strconv.Itoa(c.QueryInterval)},
+		{"carves_tls", strconv.FormatBool(c.CarvesTLS)},
+		{"carve_scan_policy", c.CarveScanPolicy},
+		{"storm_threshold", strconv.Itoa(c.StormThreshold)},
+		{"locale", c.Locale},
+		{"allowed_platforms", c.AllowedPlatforms},
+	}
+	var lines []string
+	for _, v := range values {
+		lines = append(lines, v[0]+": "+v[1])
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Helper to get one section of the configuration of an environment, as compared with templates
+func (environment *Environment) templateSection(env TLSEnvironment, section string) string {
+	switch section {
+	case ActivityOptions:
+		return env.Options
+	case ActivitySchedule:
+		return env.Schedule
+	case ActivityPacks:
+		return env.Packs
+	case ActivityDecorators:
+		return env.Decorators
+	case ActivityATC:
+		return env.ATC
+	case ActivityFlags:
+		return env.Flags
+	case TemplateSettings:
+		return environment.TemplateOf(env).SettingsText()
+	}
+	return ""
+}
+
+// Helper to get the side by side differences of the template sections between two environments
+func (environment *Environment) diffTemplateSections(from, to TLSEnvironment) TemplateDiff {
+	diff := TemplateDiff{Environment: to.Name}
+	for _, s := range TemplateSections {
+		before, after := environment.templateSection(from, s), environment.templateSection(to, s)
+		d := VersionDiff{Section: s, Changed: before != after}
+		if d.Changed {
+			diff.Changed = true
+			d.Rows = SideBySide(DiffLines(before, after))
+		}
+		diff.Diffs = append(diff.Diffs, d)
+	}
+	return diff
+}
+
+// DiffTemplate to get what would change in an environment if the configuration of a template is applied
+func (environment *Environment) DiffTemplate(env TLSEnvironment, c TemplateConfig) TemplateDiff {
+	after := env
+	environment.ApplyTemplate(&after, c)
+	diff := environment.diffTemplateSections(env, after)
+	diff.Version = env.TemplateVersion
+	return diff
+}
+
+// NewFromTemplate to generate a new environment with the configuration of a template, with fresh secrets and paths
+func (environment *Environment) NewFromTemplate(t EnvTemplate, name, hostname string) TLSEnvironment {
+	env := environment.Empty(name, hostname)
+	c := t.TemplateConfig
+	if hostname != "" {
+		c.Hostname = hostname
+	}
+	environment.ApplyTemplate(&env, c)
+	env.TemplateID = t.ID
+	env.TemplateVersion = t.Version
+	return env
+}
+
+// CreateFromTemplate to create a new environment from a template, recording the template and version used
+func (environment *Environment) CreateFromTemplate(templateName, name, hostname, author string) (TLSEnvironment, error) {
+	t, err := environment.GetTemplate(templateName)
+	if err != nil {
+		return TLSEnvironment{}, fmt.Errorf("template %s not found", templateName)
+	}
+	if name == "" || environment.Exists(name) {
+		return TLSEnvironment{}, fmt.Errorf("invalid environment %s", name)
+	}
+	env := environment.NewFromTemplate(t, name, hostname)
+	if env.Hostname == "" {
+		return env, fmt.Errorf("hostname is required, template %s does not have one", t.Name)
+	}
+	if err := environment.DB.Create(&env).Error; err != nil {
+		return env, fmt.Errorf("Create TLS Environment %v", err)
+	}
+	if err := environment.RefreshConfiguration(env.UUID); err != nil {
+		return env, err
+	}
+	summary := fmt.Sprintf("created from template %s version %d", t.Name, t.Version)
+	if err := environment.RecordActivity(env.ID, ActivityTemplate, author, summary, "", ActivityValue(env, ActivityTemplate)); err != nil {
+		return env, err
+	}
+	return environment.Get(env.UUID)
+}
+
+// CreateTemplate to create a new template as its first version
+func (environment *Environment) CreateTemplate(t EnvTemplate, author string) (EnvTemplate, error) {
+	if t.Name == "" {
+		return t, fmt.Errorf("template name is required")
+	}
+	if _, err := environment.GetTemplate(t.Name); err == nil {
+		return t, fmt.Errorf("template %s already exists", t.Name)
+	}
+	t.Version = 1
+	t.Author = author
+	err := environment.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&t).Error; err != nil {
+			return fmt.Errorf("Create %v", err)
+		}
+		v := EnvTemplateVersion{TemplateID: t.ID, Version: 1, Author: author, Note: "template created", TemplateConfig: t.TemplateConfig}
+		if err := tx.Create(&v).Error; err != nil {
+			return fmt.Errorf("Create %v", err)
+		}
+		return nil
+	})
+	return t, err
+}
+
+// UpdateTemplate to save a new configuration of a template as a new version, if it changed
+func (environment *Environment) UpdateTemplate(name, description string, c TemplateConfig, author, note string) (EnvTemplate, error) {
+	t, err := environment.GetTemplate(name)
+	if err != nil {
+		return t, fmt.Errorf("template %s not found", name)
+	}
+	changed := t.TemplateConfig != c
+	t.Description = description
+	if changed {
+		t.TemplateConfig = c
+		t.Version++
+		t.Author = author
+	}
+	err = environment.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&t).Error; err != nil {
+			return fmt.Errorf("Save %v", err)
+		}
+		if !changed {
+			return nil
+		}
+		v := EnvTemplateVersion{TemplateID: t.ID, Version: t.Version, Author: author, Note: note, TemplateConfig: c}
+		if err := tx.Create(&v).Error; err != nil {
+			return fmt.Errorf("Create %v", err)
+		}
+		return nil
+	})
+	return t, err
+}
+
+// GetTemplate to get a template by name
+func (environment *Environment) GetTemplate(name string) (EnvTemplate, error) {
+	var t EnvTemplate
+	if err := environment.DB.Where("name = ?", name).First(&t).Error; err != nil {
+		return t, err
+	}
+	return t, nil
+}
+
+// Templates to get all the templates
+func (environment *Environment) Templates() ([]EnvTemplate, error) {
+	var templates []EnvTemplate
+	if err := environment.read().Order("name").Find(&templates).Error; err != nil {
+		return templates, err
+	}
+	return templates, nil
+}
+
+// GetTemplateVersion to get one version of a template
+func (environment *Environment) GetTemplateVersion(templateID uint, version int) (EnvTemplateVersion, error) {
+	var v EnvTemplateVersion
+	if err := environment.read().Where("template_id = ? AND version = ?", templateID, version).First(&v).Error; err != nil {
+		return v, err
+	}
+	return v, nil
+}
+
+// DeleteTemplate to delete a template, environments created from it are not changed
+func (environment *Environment) DeleteTemplate(name string) error {
+	t, err := environment.GetTemplate(name)
+	if err != nil {
+		return fmt.Errorf("template %s not found", name)
+	}
+	if err := environment.DB.Delete(&t).Error; err != nil {
+		return fmt.Errorf("Delete %v", err)
+	}
+	return nil
+}
+
+// DerivedEnvironments to get the environments created from a template
+func (environment *Environment) DerivedEnvironments(templateID uint) ([]TLSEnvironment, error) {
+	var envs []TLSEnvironment
+	if err := environment.read().Where("template_id = ?", templateID).Order("name").Find(&envs).Error; err != nil {
+		return envs, err
+	}
+	return envs, nil
+}
+
+// PropagateDiffs to get what would change in each environment created from a template if it is propagated
+func (environment *Environment) PropagateDiffs(name string) (EnvTemplate, []TemplateDiff, error) {
+	t, err := environment.GetTemplate(name)
+	if err != nil {
+		return t, nil, fmt.Errorf("template %s not found", name)
+	}
+	envs, err := environment.DerivedEnvironments(t.ID)
+	if err != nil {
+		return t, nil, err
+	}
+	diffs := []TemplateDiff{}
+	for _, e := range envs {
+		diffs = append(diffs, environment.DiffTemplate(e, t.TemplateConfig))
+	}
+	return t, diffs, nil
+}
+
+// PropagateTemplate to apply the current version of a template to environments created from it, by name or UUID.
+// Each change is recorded as activity and as a new configuration version of the environment.
+func (environment *Environment) PropagateTemplate(name string, identifiers []string, author string) ([]TLSEnvironment, error) {
+	var updated []TLSEnvironment
+	t, err := environment.GetTemplate(name)
+	if err != nil {
+		return updated, fmt.Errorf("template %s not found", name)
+	}
+	// All environments must come from the template before anything is changed
+	var envs []TLSEnvironment
+	for _, id := range identifiers {
+		env, err := environment.Get(id)
+		if err != nil {
+			return updated, fmt.Errorf("environment %s not found", id)
+		}
+		if env.TemplateID != t.ID {
+			return updated, fmt.Errorf("environment %s was not created from template %s", env.Name, t.Name)
+		}
+		envs = append(envs, env)
+	}
+	summary := fmt.Sprintf("propagated from template %s version %d", t.Name, t.Version)
+	for _, before := range envs {
+		after := before
+		environment.ApplyTemplate(&after, t.TemplateConfig)
+		err := environment.DB.Model(&TLSEnvironment{}).Where("id = ?", before.ID).Updates(map[string]interface{}{
+			"hostname":          after.Hostname,
+			"type":              after.Type,
+			"icon":              after.Icon,
+			"debug_http":        after.DebugHTTP,
+			"options":           after.Options,
+			"schedule":          after.Schedule,
+			"packs":             after.Packs,
+			"decorators":        after.Decorators,
+			"atc":               after.ATC,
+			"flags":             after.Flags,
+			"config_tls":        after.ConfigTLS,
+			"config_interval":   after.ConfigInterval,
+			"logging_tls":       after.LoggingTLS,
+			"log_interval":      after.LogInterval,
+			"query_tls":         after.QueryTLS,
+			"query_interval":    after.QueryInterval,
+			"carves_tls":        after.CarvesTLS,
+			"carve_scan_policy": after.CarveScanPolicy,
+			"storm_threshold":   after.StormThreshold,
+			"locale":            after.Locale,
+			"allowed_platforms": after.AllowedPlatforms,
+			"template_version":  t.Version,
+		}).Error
+		if err != nil {
+			return updated, fmt.Errorf("Updates %v", err)
+		}
+		if err := environment.RefreshConfiguration(before.UUID); err != nil {
+			return updated, err
+		}
+		if err := environment.RecordChange(before, ActivityTemplate, author, summary); err != nil {
+			return updated, err
+		}
+		if err := environment.RecordChange(before, ActivityConfiguration, author, summary); err != nil {
+			return updated, err
+		}
+		env, err := environment.Get(before.UUID)
+		if err != nil {
+			return updated, err
+		}
+		updated = append(updated, env)
+	}
+	return updated, nil
+}
+
+// TemplateDrift to get what changed in an environment since it was created or updated from its template version
+func (environment *Environment) TemplateDrift(env TLSEnvironment) (EnvTemplate, TemplateDiff, error) {
+	var t EnvTemplate
+	if env.TemplateID == 0 {
+		return t, TemplateDiff{}, fmt.Errorf("environment %s was not created from a template", env.Name)
+	}
+	if err := environment.read().Unscoped().First(&t, env.TemplateID).Error; err != nil {
+		return t, TemplateDiff{}, err
+	}
+	v, err := environment.GetTemplateVersion(t.ID, env.TemplateVersion)
+	if err != nil {
+		return t, TemplateDiff{}, err
+	}
+	// Compare from the template version to the environment
+	from := env
+	environment.ApplyTemplate(&from, v.TemplateConfig)
+	diff := environment.diffTemplateSections(from, env)
+	diff.Version = env.TemplateVersion
+	return t, diff, nil
+}
@@ -0,0 +1,89 @@
+package environments
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testTemplate() EnvTemplate {
+	return EnvTemplate{
+		Name:    "org-standard",
+		Version: 3,
+		TemplateConfig: TemplateConfig{
+			Hostname:        "osctrl.example.com",
+			Type:            DefaultEnvironmentType,
+			Icon:            DefaultEnvironmentIcon,
+			Options:         `{"host_identifier": "uuid"}`,
+			Schedule:        `{"uptime": {"query": "SELECT * FROM uptime;", "interval": 60}}`,
+			Packs:           "{}",
+			Decorators:      "{}",
+			ATC:             "{}",
+			Flags:           "--watchdog_level=1",
+			ConfigTLS:       true,
+			ConfigInterval:  120,
+			LoggingTLS:      true,
+			LogInterval:     60,
+			QueryTLS:        true,
+			QueryInterval:   30,
+			CarvesTLS:       true,
+			CarveScanPolicy: "enforce",
+			Locale:          "es",
+		},
+	}
+}
+
+func TestNewFromTemplate(t *testing.T) {
+	e := &Environment{}
+	tmpl := testTemplate()
+	env1 := e.NewFromTemplate(tmpl, "new-env", "")
+	env2 := e.NewFromTemplate(tmpl, "other-env", "other.example.com")
+	assert.Equal(t, "new-env", env1.Name)
+	assert.Equal(t, "osctrl.example.com", env1.Hostname)
+	assert.Equal(t, "other.example.com", env2.Hostname)
+	assert.Equal(t, tmpl.Schedule, env1.Schedule)
+	assert.Equal(t, 120, env1.ConfigInterval)
+	assert.Equal(t, "enforce", env1.CarveScanPolicy)
+	assert.Equal(t, tmpl.ID, env1.TemplateID)
+	assert.Equal(t, 3, env1.TemplateVersion)
+	// Secrets and paths are never copied
+	assert.NotEqual(t, env1.UUID, env2.UUID)
+	assert.NotEqual(t, env1.Secret, env2.Secret)
+	assert.NotEqual(t, env1.EnrollSecretPath, env2.EnrollSecretPath)
+	// Flags are generated for each environment with the extra flags
+	assert.True(t, strings.Contains(env1.Flags, "--config_tls_refresh=120"))
+	assert.True(t, strings.Contains(env1.Flags, "/"+env1.UUID+"/"))
+	assert.True(t, strings.Contains(env1.Flags, "--watchdog_level=1"))
+	assert.Equal(t, "--watchdog_level=1", e.ExtraFlags(env1))
+	// The template of an environment is the one it came from
+	c := e.TemplateOf(env1)
+	assert.Equal(t, tmpl.TemplateConfig, c)
+}
+
+func TestDiffTemplate(t *testing.T) {
+	e := &Environment{}
+	tmpl := testTemplate()
+	env := e.NewFromTemplate(tmpl, "new-env", "")
+	diff := e.DiffTemplate(env, tmpl.TemplateConfig)
+	assert.False(t, diff.Changed)
+	assert.Len(t, diff.Diffs, len(TemplateSections))
+	// A new version of the template changes the schedule and the intervals of the flags
+	tmpl.Schedule = "{}"
+	tmpl.ConfigInterval = 300
+	diff = e.DiffTemplate(env, tmpl.TemplateConfig)
+	assert.True(t, diff.Changed)
+	assert.Equal(t, "new-env", diff.Environment)
+	for _, d := range diff.Diffs {
+		changed := d.Section == ActivitySchedule || d.Section == ActivityFlags || d.Section == TemplateSettings
+		assert.Equal(t, changed, d.Changed, d.Section)
+		assert.Equal(t, changed, len(d.Rows) > 0, d.Section)
+	}
+}
+
+func TestTemplateSettingsText(t *testing.T) {
+	text := testTemplate().SettingsText()
+	assert.True(t, strings.Contains(text, "config_interval: 120\n"))
+	assert.True(t, strings.Contains(text, "carve_scan_policy: enforce\n"))
+	assert.True(t, strings.HasPrefix(text, "hostname: osctrl.example.com\n"))
+}
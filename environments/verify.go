@@ -0,0 +1,650 @@
+package environments
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Severities of the issues found verifying flags and configuration
+const (
+	VerifyError   string = "error"
+	VerifyWarning string = "warning"
+)
+
+// Sources of the issues found verifying flags and configuration
+const (
+	VerifyFlags    string = "flags"
+	VerifyConfig   string = "config"
+	VerifyOsqueryd string = "osqueryd"
+)
+
+const (
+	// DefaultOsquerydTimeout is how long the osqueryd check can run before it is killed
+	DefaultOsquerydTimeout = 30 * time.Second
+	// maxOsquerydOutput is the maximum output of the osqueryd check that is kept
+	maxOsquerydOutput = 16 * 1024
+)
+
+// Types of values of flags
+const (
+	flagBool   string = "bool"
+	flagInt    string = "int"
+	flagString string = "string"
+)
+
+// FlagSpec to describe a flag of osquery, with the first version that has it and the first version that does not.
+// Empty versions mean all versions.
+type FlagSpec struct {
+	Type    string `json:"type"`
+	Since   string `json:"since,omitempty"`
+	Removed string `json:"removed,omitempty"`
+}
+
+// FlagCatalog is the bundled catalog of the flags of osquery that osctrl serves or that are common in options.
+// The versions that introduced flags are kept in FlagVersions.
+var FlagCatalog = map[string]FlagSpec{
+	"host_identifier":                {Type: flagString},
+	"force":                          {Type: flagBool},
+	"utc":                            {Type: flagBool},
+	"verbose":                        {Type: flagBool},
+	"ephemeral":                      {Type: flagBool},
+	"database_path":                  {Type: flagString},
+	"disable_database":               {Type: flagBool},
+	"disable_watchdog":               {Type: flagBool},
+	"disable_extensions":             {Type: flagBool},
+	"extensions_autoload":            {Type: flagString},
+	"watchdog_level":                 {Type: flagInt},
+	"watchdog_memory_limit":          {Type: flagInt},
+	"watchdog_utilization_limit":     {Type: flagInt},
+	"watchdog_delay":                 {Type: flagInt},
+	"worker_threads":                 {Type: flagInt},
+	"enroll_secret_path":             {Type: flagString},
+	"enroll_tls_endpoint":            {Type: flagString},
+	"config_plugin":                  {Type: flagString},
+	"config_path":                    {Type: flagString},
+	"config_refresh":                 {Type: flagInt},
+	"config_accelerated_refresh":     {Type: flagInt},
+	"config_tls_endpoint":            {Type: flagString},
+	"config_tls_refresh":             {Type: flagInt},
+	"config_tls_max_attempts":        {Type: flagInt},
+	"logger_plugin":                  {Type: flagString},
+	"logger_path":                    {Type: flagString},
+	"logger_min_status":              {Type: flagInt},
+	"logger_event_type":              {Type: flagBool},
+	"logger_snapshot_event_type":     {Type: flagBool},
+	"logger_tls_compress":            {Type: flagBool},
+	"logger_tls_endpoint":            {Type: flagString},
+	"logger_tls_period":              {Type: flagInt},
+	"logger_tls_max_lines":           {Type: flagInt},
+	"disable_logging":                {Type: flagBool},
+	"disable_carver":                 {Type: flagBool},
+	"carver_disable_function":        {Type: flagBool},
+	"carver_start_endpoint":          {Type: flagString},
+	"carver_continue_endpoint":       {Type: flagString},
+	"carver_block_size":              {Type: flagInt},
+	"disable_distributed":            {Type: flagBool},
+	"distributed_interval":           {Type: flagInt},
+	"distributed_plugin":             {Type: flagString},
+	"distributed_tls_max_attempts":   {Type: flagInt},
+	"distributed_tls_read_endpoint":  {Type: flagString},
+	"distributed_tls_write_endpoint": {Type: flagString},
+	"tls_hostname":                   {Type: flagString},
+	"tls_server_certs":               {Type: flagString},
+	"schedule_splay_percent":         {Type: flagInt},
+	"schedule_default_interval":      {Type: flagInt},
+	"schedule_timeout":               {Type: flagInt},
+	"pack_delimiter":                 {Type: flagString},
+	"pack_refresh_interval":          {Type: flagInt},
+	"decorations_top_level":          {Type: flagBool},
+	"disable_events":                 {Type: flagBool},
+	"events_expiry":                  {Type: flagInt},
+	"events_max":                     {Type: flagInt},
+	"events_optimize":                {Type: flagBool},
+	"disable_audit":                  {Type: flagBool},
+	"audit_allow_config":             {Type: flagBool},
+	"audit_allow_sockets":            {Type: flagBool},
+	"audit_persist":                  {Type: flagBool},
+	"disable_tables":                 {Type: flagString},
+	"table_delay":                    {Type: flagInt},
+	"read_max":                       {Type: flagInt},
+	"hash_cache_max":                 {Type: flagInt},
+	"disable_kernel":                 {Type: flagBool, Removed: "4.0.0"},
+	"enable_bpf_events":              {Type: flagBool},
+	"disable_endpointsecurity":       {Type: flagBool},
+	"disable_endpointsecurity_fim":   {Type: flagBool},
+	"es_fim_mute_path_literal":       {Type: flagString},
+	"es_fim_mute_path_prefix":        {Type: flagString},
+}
+
+// ConfigKeys are the top level keys of the configuration of osquery, with the first version that reads them
+var ConfigKeys = map[string]string{
+	"options":                 "",
+	"schedule":                "",
+	"packs":                   "",
+	"decorators":              "",
+	"auto_table_construction": "",
+	"file_paths":              "",
+	"file_paths_query":        "",
+	"exclude_paths":           "",
+	"file_accesses":           "",
+	"yara":                    "",
+	"prometheus_targets":      "",
+	"views":                   "",
+	"events":                  "",
+	"feature_vectors":         "",
+}
+
+// QueryKeys are the keys of a query in the schedule or in a pack, with the first version that reads them
+var QueryKeys = map[string]string{
+	"query":       "",
+	"interval":    "",
+	"platform":    "",
+	"version":     "",
+	"shard":       "",
+	"snapshot":    "",
+	"removed":     "",
+	"description": "",
+	"value":       "",
+	"blacklist":   "",
+	"denylist":    "4.6.0",
+}
+
+// VerifyIssue to pinpoint a flag or option that a version of osquery does not accept
+type VerifyIssue struct {
+	Severity string `json:"severity"`
+	Source   string `json:"source"`
+	Name     string `json:"name"`
+	Line     int    `json:"line,omitempty"`
+	Message  string `json:"message"`
+}
+
+// String to describe an issue in one line
+func (i VerifyIssue) String() string {
+	if i.Line > 0 {
+		return fmt.Sprintf("%s line %d %s: %s", i.Source, i.Line, i.Name, i.Message)
+	}
+	return fmt.Sprintf("%s %s: %s", i.Source, i.Name, i.Message)
+}
+
+// VerifyTarget is a version of osquery in a platform to verify the flags and configuration served against
+type VerifyTarget struct {
+	Platform string `json:"platform"`
+	Version  string `json:"version"`
+}
+
+// VerifyResult to hold the issues of the flags and configuration served to a platform for a version of osquery
+type VerifyResult struct {
+	Platform string        `json:"platform"`
+	Version  string        `json:"version"`
+	Profile  string        `json:"profile,omitempty"`
+	OK       bool          `json:"ok"`
+	Issues   []VerifyIssue `json:"issues"`
+}
+
+// OsquerydCheck to hold the output of a local osqueryd checking the flags and configuration served to a platform
+type OsquerydCheck struct {
+	Platform string        `json:"platform"`
+	Binary   string        `json:"binary"`
+	Version  string        `json:"version"`
+	OK       bool          `json:"ok"`
+	Output   string        `json:"output"`
+	Issues   []VerifyIssue `json:"issues"`
+}
+
+// VerifyReport to hold the verification of the flags and configuration of an environment for all targets
+type VerifyReport struct {
+	Environment string          `json:"environment"`
+	OK          bool            `json:"ok"`
+	Results     []VerifyResult  `json:"results"`
+	Osqueryd    []OsquerydCheck `json:"osqueryd,omitempty"`
+}
+
+// Failures to get the errors of a report in one line each, prefixed with their version and platform
+func (r VerifyReport) Failures() []string {
+	var failures []string
+	for _, res := range r.Results {
+		for _, i := range res.Issues {
+			if i.Severity == VerifyError {
+				failures = append(failures, fmt.Sprintf("osquery %s (%s): %s", res.Version, res.Platform, i))
+			}
+		}
+	}
+	for _, c := range r.Osqueryd {
+		for _, i := range c.Issues {
+			failures = append(failures, fmt.Sprintf("osqueryd %s (%s): %s", c.Version, c.Platform, i))
+		}
+	}
+	return failures
+}
+
+// LookupFlag to get the specification of a flag from the catalog, with the version that introduced it
+func LookupFlag(name string) (FlagSpec, bool) {
+	spec, ok := FlagCatalog[name]
+	if since, known := FlagVersions[name]; known {
+		spec.Since = since
+		if spec.Type == "" {
+			spec.Type = flagString
+		}
+		ok = true
+	}
+	return spec, ok
+}
+
+// Helper to check that a version of osquery is between the versions of a catalog entry, empty version always is
+func versionIssue(name, since, removed, version string) string {
+	if version == "" {
+		return ""
+	}
+	if since != "" && CompareVersions(version, since) < 0 {
+		return fmt.Sprintf("%s requires osquery %s", name, since)
+	}
+	if removed != "" && CompareVersions(version, removed) >= 0 {
+		return fmt.Sprintf("%s was removed in osquery %s", name, removed)
+	}
+	return ""
+}
+
+// Helper to check a value of a flag against its type, values from flags files are always strings
+func typeIssue(spec FlagSpec, value interface{}) string {
+	switch v := value.(type) {
+	case bool:
+		if spec.Type != flagBool && spec.Type != flagString {
+			return fmt.Sprintf("expects %s, got a boolean", spec.Type)
+		}
+	case float64:
+		if spec.Type == flagBool {
+			if v != 0 && v != 1 {
+				return "expects bool, got a number"
+			}
+		} else if spec.Type == flagInt && v != float64(int64(v)) {
+			return "expects int, got a decimal number"
+		}
+	case string:
+		switch spec.Type {
+		case flagBool:
+			if _, err := strconv.ParseBool(v); err != nil {
+				return fmt.Sprintf("expects bool, got %q", v)
+			}
+		case flagInt:
+			if _, err := strconv.ParseInt(v, 10, 64); err != nil {
+				return fmt.Sprintf("expects int, got %q", v)
+			}
+		}
+	case nil:
+		return fmt.Sprintf("expects %s, got null", spec.Type)
+	default:
+		return fmt.Sprintf("expects %s, got an object or list", spec.Type)
+	}
+	return ""
+}
+
+// Helper to check one flag or option, unknown ones are warnings since the catalog is not complete
+func verifyFlag(source, name string, line int, value interface{}, hasValue bool, version string) []VerifyIssue {
+	spec, ok := LookupFlag(name)
+	if !ok {
+		return []VerifyIssue{{Severity: VerifyWarning, Source: source, Name: name, Line: line, Message: "not in the catalog of known flags"}}
+	}
+	var issues []VerifyIssue
+	if msg := versionIssue("--"+name, spec.Since, spec.Removed, version); msg != "" {
+		issues = append(issues, VerifyIssue{Severity: VerifyError, Source: source, Name: name, Line: line, Message: msg})
+	}
+	// Boolean flags can be set without a value
+	if !hasValue && spec.Type == flagBool {
+		return issues
+	}
+	if msg := typeIssue(spec, value); msg != "" {
+		issues = append(issues, VerifyIssue{Severity: VerifyError, Source: source, Name: name, Line: line, Message: msg})
+	}
+	return issues
+}
+
+// VerifyFlagsFile to check the lines of a flags file against the catalog for a version of osquery, empty for any version
+func VerifyFlagsFile(flags, version string) []VerifyIssue {
+	issues := []VerifyIssue{}
+	for i, line := range strings.Split(flags, "\n") {
+		name := flagName(line)
+		if name == "" {
+			continue
+		}
+		trimmed := strings.TrimLeft(strings.TrimSpace(line), "-")
+		var value interface{}
+		hasValue := false
+		if j := strings.IndexAny(trimmed, "= "); j >= 0 {
+			value = strings.TrimSpace(trimmed[j+1:])
+			hasValue = true
+		}
+		issues = append(issues, verifyFlag(VerifyFlags, name, i+1, value, hasValue, version)...)
+	}
+	return issues
+}
+
+// Helper to get the line of an offset in a serialized value
+func lineOf(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+// Helper to check the queries of the schedule or a pack
+func verifyQueries(prefix string, queries map[string]json.RawMessage, version string) []VerifyIssue {
+	var issues []VerifyIssue
+	for _, name := range sortedKeys(queries) {
+		path := prefix + "." + name
+		var query map[string]interface{}
+		if err := json.Unmarshal(queries[name], &query); err != nil {
+			issues = append(issues, VerifyIssue{Severity: VerifyError, Source: VerifyConfig, Name: path, Message: "query must be an object"})
+			continue
+		}
+		if q, ok := query["query"].(string); !ok || strings.TrimSpace(q) == "" {
+			issues = append(issues, VerifyIssue{Severity: VerifyError, Source: VerifyConfig, Name: path + ".query", Message: "query is required"})
+		}
+		if interval, ok := query["interval"]; ok {
+			if msg := typeIssue(FlagSpec{Type: flagInt}, interval); msg != "" {
+				issues = append(issues, VerifyIssue{Severity: VerifyError, Source: VerifyConfig, Name: path + ".interval", Message: msg})
+			}
+		}
+		for _, key := range sortedKeys(query) {
+			since, known := QueryKeys[key]
+			if !known {
+				issues = append(issues, VerifyIssue{Severity: VerifyWarning, Source: VerifyConfig, Name: path + "." + key, Message: "unknown query key, ignored by osquery"})
+				continue
+			}
+			if msg := versionIssue(key, since, "", version); msg != "" {
+				issues = append(issues, VerifyIssue{Severity: VerifyError, Source: VerifyConfig, Name: path + "." + key, Message: msg})
+			}
+		}
+	}
+	return issues
+}
+
+// VerifyConfiguration to check a serialized configuration against the schema for a version of osquery, empty for any version
+func VerifyConfiguration(configuration []byte, version string) []VerifyIssue {
+	issues := []VerifyIssue{}
+	var conf map[string]json.RawMessage
+	if err := json.Unmarshal(configuration, &conf); err != nil {
+		issue := VerifyIssue{Severity: VerifyError, Source: VerifyConfig, Name: "configuration", Message: err.Error()}
+		if syntax, ok := err.(*json.SyntaxError); ok {
+			issue.Line = lineOf(configuration, syntax.Offset)
+		}
+		return append(issues, issue)
+	}
+	for _, key := range sortedKeys(conf) {
+		since, known := ConfigKeys[key]
+		if !known {
+			issues = append(issues, VerifyIssue{Severity: VerifyWarning, Source: VerifyConfig, Name: key, Message: "unknown configuration key, ignored by osquery"})
+			continue
+		}
+		if msg := versionIssue(key, since, "", version); msg != "" {
+			issues = append(issues, VerifyIssue{Severity: VerifyError, Source: VerifyConfig, Name: key, Message: msg})
+		}
+	}
+	if raw, ok := conf["options"]; ok {
+		var options map[string]interface{}
+		if err := json.Unmarshal(raw, &options); err != nil {
+			issues = append(issues, VerifyIssue{Severity: VerifyError, Source: VerifyConfig, Name: "options", Message: "options must be an object"})
+		}
+		for _, name := range sortedKeys(options) {
+			for _, i := range verifyFlag(VerifyConfig, name, 0, options[name], true, version) {
+				i.Name = "options." + i.Name
+				issues = append(issues, i)
+			}
+		}
+	}
+	if raw, ok := conf["schedule"]; ok {
+		var schedule map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &schedule); err != nil {
+			issues = append(issues, VerifyIssue{Severity: VerifyError, Source: VerifyConfig, Name: "schedule", Message: "schedule must be an object"})
+		}
+		issues = append(issues, verifyQueries("schedule", schedule, version)...)
+	}
+	if raw, ok := conf["packs"]; ok {
+		var packs map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &packs); err != nil {
+			issues = append(issues, VerifyIssue{Severity: VerifyError, Source: VerifyConfig, Name: "packs", Message: "packs must be an object"})
+		}
+		for _, name := range sortedKeys(packs) {
+			// Packs can also be paths to files in the nodes
+			var pack struct {
+				Queries map[string]json.RawMessage `json:"queries"`
+			}
+			if json.Unmarshal(packs[name], &pack) != nil {
+				continue
+			}
+			issues = append(issues, verifyQueries("packs."+name, pack.Queries, version)...)
+		}
+	}
+	return issues
+}
+
+// Verify to check flags and a serialized configuration for a target
+func Verify(flags string, configuration []byte, target VerifyTarget) VerifyResult {
+	res := VerifyResult{Platform: target.Platform, Version: target.Version, OK: true}
+	res.Issues = append(VerifyFlagsFile(flags, target.Version), VerifyConfiguration(configuration, target.Version)...)
+	for _, i := range res.Issues {
+		if i.Severity == VerifyError {
+			res.OK = false
+		}
+	}
+	return res
+}
+
+// VerifyTargets to get the targets to verify from platforms and versions of nodes, without duplicates or
+// unknown versions, sorted by platform and version
+func VerifyTargets(targets []VerifyTarget) []VerifyTarget {
+	seen := make(map[VerifyTarget]bool)
+	res := []VerifyTarget{}
+	for _, t := range targets {
+		if !ValidOsqueryVersion(t.Version) || seen[t] {
+			continue
+		}
+		seen[t] = true
+		res = append(res, t)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Platform != res[j].Platform {
+			return res[i].Platform < res[j].Platform
+		}
+		return CompareVersions(res[i].Version, res[j].Version) < 0
+	})
+	return res
+}
+
+// FleetTargets to get the targets for the osquery versions of nodes by platform, adding the versions pinned for them
+func FleetTargets(env TLSEnvironment, versions []VerifyTarget) []VerifyTarget {
+	targets := append([]VerifyTarget{}, versions...)
+	for _, v := range versions {
+		targets = append(targets, VerifyTarget{Platform: v.Platform, Version: PinnedVersion(env, v.Platform)})
+	}
+	return VerifyTargets(targets)
+}
+
+// SelectTargets to get the targets to verify from the osquery versions of nodes by platform. Only one platform is
+// kept when given, and a version replaces the versions of the nodes, verifying it for their platforms.
+func SelectTargets(env TLSEnvironment, fleet []VerifyTarget, platform, version string) []VerifyTarget {
+	var targets []VerifyTarget
+	for _, t := range fleet {
+		if platform != "" && t.Platform != platform {
+			continue
+		}
+		if version != "" {
+			t.Version = version
+		}
+		targets = append(targets, t)
+	}
+	if version == "" {
+		return FleetTargets(env, targets)
+	}
+	if len(targets) == 0 {
+		return []VerifyTarget{{Platform: platform, Version: version}}
+	}
+	return VerifyTargets(targets)
+}
+
+// ServedConfig to render the flags and configuration served to nodes of a platform with a performance profile,
+// an empty profile for nodes without one. Schedule overrides of single nodes are not included.
+func (environment *Environment) ServedConfig(env TLSEnvironment, profile PerformanceProfile, channels []WindowsEventChannel, platform string) (string, []byte, error) {
+	flags, err := environment.GenerateFlags(env.WithProfile(profile), "", "")
+	if err != nil {
+		return flags, nil, err
+	}
+	conf, err := ResolveConflicts([]byte(env.Configuration))
+	if err != nil {
+		return flags, conf, err
+	}
+	if profile.Name != "" {
+		if conf, err = ApplyProfile(conf, profile); err != nil {
+			return flags, conf, err
+		}
+	}
+	if strings.EqualFold(platform, WindowsEventsPlatform) {
+		if conf, err = ApplyWindowsEvents(conf, channels); err != nil {
+			return flags, conf, err
+		}
+	}
+	return flags, conf, nil
+}
+
+// VerifyServed to verify the flags and configuration served to each target, and with a local osqueryd once per
+// platform when a checker is given
+func (environment *Environment) VerifyServed(ctx context.Context, env TLSEnvironment, profile PerformanceProfile, channels []WindowsEventChannel, targets []VerifyTarget, checker *OsquerydChecker) (VerifyReport, error) {
+	report := VerifyReport{Environment: env.Name, OK: true, Results: []VerifyResult{}}
+	checked := make(map[string]bool)
+	for _, t := range targets {
+		flags, conf, err := environment.ServedConfig(env, profile, channels, t.Platform)
+		if err != nil {
+			return report, fmt.Errorf("error rendering configuration for %s - %v", t.Platform, err)
+		}
+		res := Verify(flags, conf, t)
+		res.Profile = profile.Name
+		report.Results = append(report.Results, res)
+		report.OK = report.OK && res.OK
+		if checker != nil && !checked[t.Platform] {
+			checked[t.Platform] = true
+			check := checker.Check(ctx, flags, conf)
+			check.Platform = t.Platform
+			report.Osqueryd = append(report.Osqueryd, check)
+			report.OK = report.OK && check.OK
+		}
+	}
+	return report, nil
+}
+
+// OsquerydChecker to run a local osqueryd to check flags and configuration, in a temporary directory and without
+// the environment of the service. It is only used when a binary is configured.
+type OsquerydChecker struct {
+	Binary  string
+	Timeout time.Duration
+}
+
+// NewOsquerydChecker to get a checker for a binary, nil when no binary is configured
+func NewOsquerydChecker(binary string) *OsquerydChecker {
+	if strings.TrimSpace(binary) == "" {
+		return nil
+	}
+	return &OsquerydChecker{Binary: binary, Timeout: DefaultOsquerydTimeout}
+}
+
+var (
+	osquerydVersionRegexp = regexp.MustCompile(`version ([0-9]+\.[0-9]+\.[0-9]+)`)
+	osquerydFlagRegexp    = regexp.MustCompile(`unknown command line flag '([^']+)'`)
+	osquerydOptionRegexp  = regexp.MustCompile(`(?i)(?:option|flag)[^']*'([^']+)'`)
+)
+
+// Helper to run the binary with a timeout, returning the combined output
+func (c *OsquerydChecker) run(ctx context.Context, dir string, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, c.Binary, args...)
+	cmd.Dir = dir
+	cmd.Env = []string{"PATH=/usr/bin:/bin"}
+	out, err := cmd.CombinedOutput()
+	if len(out) > maxOsquerydOutput {
+		out = out[:maxOsquerydOutput]
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return out, fmt.Errorf("timed out after %s", c.Timeout)
+	}
+	return out, err
+}
+
+// ParseOsquerydOutput to get the issues in the output of a failed osqueryd check, pinpointing flags when possible
+func ParseOsquerydOutput(output string) []VerifyIssue {
+	var issues []VerifyIssue
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		name := ""
+		if m := osquerydFlagRegexp.FindStringSubmatch(line); m != nil {
+			name = m[1]
+		} else if m := osquerydOptionRegexp.FindStringSubmatch(line); m != nil {
+			name = m[1]
+		} else if !strings.Contains(strings.ToLower(line), "error") {
+			continue
+		}
+		issues = append(issues, VerifyIssue{Severity: VerifyError, Source: VerifyOsqueryd, Name: name, Message: line})
+	}
+	return issues
+}
+
+// Check to run osqueryd with the flags file and --config_check on the configuration. Unknown flags in the flags
+// file make osqueryd fail before checking the configuration. Plugins are replaced so nothing is sent anywhere.
+func (c *OsquerydChecker) Check(ctx context.Context, flags string, configuration []byte) OsquerydCheck {
+	check := OsquerydCheck{Binary: c.Binary, Issues: []VerifyIssue{}}
+	dir, err := os.MkdirTemp("", "osctrl-verify-")
+	if err != nil {
+		check.Issues = append(check.Issues, VerifyIssue{Severity: VerifyError, Source: VerifyOsqueryd, Message: err.Error()})
+		return check
+	}
+	defer os.RemoveAll(dir)
+	if out, err := c.run(ctx, dir, "--version"); err == nil {
+		if m := osquerydVersionRegexp.FindSubmatch(out); m != nil {
+			check.Version = string(m[1])
+		}
+	}
+	flagsPath := filepath.Join(dir, "osquery.flags")
+	confPath := filepath.Join(dir, "osquery.conf")
+	if err := os.WriteFile(flagsPath, []byte(flags), 0600); err != nil {
+		check.Issues = append(check.Issues, VerifyIssue{Severity: VerifyError, Source: VerifyOsqueryd, Message: err.Error()})
+		return check
+	}
+	if err := os.WriteFile(confPath, configuration, 0600); err != nil {
+		check.Issues = append(check.Issues, VerifyIssue{Severity: VerifyError, Source: VerifyOsqueryd, Message: err.Error()})
+		return check
+	}
+	out, err := c.run(ctx, dir,
+		"--flagfile="+flagsPath,
+		"--config_plugin=filesystem",
+		"--config_path="+confPath,
+		"--config_check",
+		"--logger_plugin=filesystem",
+		"--logger_path="+dir,
+		"--disable_distributed=true",
+		"--disable_extensions=true",
+		"--disable_watchdog=true",
+		"--database_path="+filepath.Join(dir, "osquery.db"),
+		"--pidfile="+filepath.Join(dir, "osquery.pid"),
+	)
+	check.Output = string(out)
+	if err == nil {
+		check.OK = true
+		return check
+	}
+	check.Issues = ParseOsquerydOutput(check.Output)
+	if len(check.Issues) == 0 {
+		check.Issues = append(check.Issues, VerifyIssue{Severity: VerifyError, Source: VerifyOsqueryd, Message: err.Error()})
+	}
+	return check
+}
@@ -0,0 +1,124 @@
+package environments
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookupFlag(t *testing.T) {
+	spec, ok := LookupFlag("es_fim_mute_path_literal")
+	assert.True(t, ok)
+	assert.Equal(t, "5.5.0", spec.Since)
+	spec, ok = LookupFlag("disable_kernel")
+	assert.True(t, ok)
+	assert.Equal(t, "4.0.0", spec.Removed)
+	_, ok = LookupFlag("not_a_flag")
+	assert.False(t, ok)
+	// All the flags served by osctrl are known
+	flags, err := (&Environment{}).GenerateFlags(TLSEnvironment{Certificate: "cert", ConfigInterval: 300}, "", "")
+	assert.NoError(t, err)
+	for _, i := range VerifyFlagsFile(flags, "5.9.1") {
+		assert.NotEqual(t, VerifyWarning, i.Severity, i.String())
+	}
+}
+
+func TestVerifyFlagsFile(t *testing.T) {
+	flags := "# comment\n--utc=true\n--disable_endpointsecurity_fim\n--logger_tls_period=ten\n--not_a_flag=1\n--disable_kernel=true"
+	issues := VerifyFlagsFile(flags, "5.2.2")
+	assert.Len(t, issues, 4)
+	assert.Equal(t, VerifyIssue{Severity: VerifyError, Source: VerifyFlags, Name: "disable_endpointsecurity_fim", Line: 3, Message: "--disable_endpointsecurity_fim requires osquery 5.3.0"}, issues[0])
+	assert.Equal(t, "logger_tls_period", issues[1].Name)
+	assert.Equal(t, `expects int, got "ten"`, issues[1].Message)
+	assert.Equal(t, VerifyWarning, issues[2].Severity)
+	assert.Equal(t, 5, issues[2].Line)
+	assert.Equal(t, "--disable_kernel was removed in osquery 4.0.0", issues[3].Message)
+	// Without a version only types are checked
+	issues = VerifyFlagsFile("--disable_endpointsecurity_fim=maybe", "")
+	assert.Len(t, issues, 1)
+	assert.Equal(t, `expects bool, got "maybe"`, issues[0].Message)
+}
+
+func TestVerifyConfiguration(t *testing.T) {
+	conf := []byte(`{
+  "options": {"distributed_interval": "60", "utc": 1, "disable_kernel": false, "logger_tls_period": true},
+  "schedule": {
+    "uptime": {"query": "SELECT * FROM uptime;", "interval": 3600, "denylist": false},
+    "broken": {"interval": "often"}
+  },
+  "packs": {"external": "/etc/osquery/packs/it.conf", "inline": {"queries": {"q": {"query": "SELECT 1;", "interval": 60, "color": "red"}}}},
+  "unknown": {}
+}`)
+	issues := VerifyConfiguration(conf, "4.5.0")
+	var names []string
+	for _, i := range issues {
+		names = append(names, i.Severity+" "+i.Name)
+	}
+	assert.Equal(t, []string{
+		"warning unknown",
+		"error options.disable_kernel",
+		"error options.logger_tls_period",
+		"error schedule.broken.query",
+		"error schedule.broken.interval",
+		"error schedule.uptime.denylist",
+		"warning packs.inline.q.color",
+	}, names)
+	assert.Empty(t, VerifyConfiguration([]byte(`{"options": {"utc": true}}`), "5.9.1"))
+	// Invalid JSON is pinpointed by line
+	issues = VerifyConfiguration([]byte("{\n  \"options\": {\n    \"utc\": true,\n  }\n}"), "")
+	assert.Len(t, issues, 1)
+	assert.Equal(t, 4, issues[0].Line)
+}
+
+func TestVerify(t *testing.T) {
+	res := Verify("--utc=true", []byte(`{"options": {"es_fim_mute_path_prefix": "/tmp"}}`), VerifyTarget{Platform: "darwin", Version: "5.4.0"})
+	assert.False(t, res.OK)
+	assert.Equal(t, "options.es_fim_mute_path_prefix", res.Issues[0].Name)
+	res = Verify("--utc=true", []byte(`{"options": {"es_fim_mute_path_prefix": "/tmp"}}`), VerifyTarget{Platform: "darwin", Version: "5.9.1"})
+	assert.True(t, res.OK)
+	report := VerifyReport{Results: []VerifyResult{res, Verify("--utc=maybe", []byte(`{}`), VerifyTarget{Platform: "linux", Version: "5.9.1"})}}
+	assert.Equal(t, []string{`osquery 5.9.1 (linux): flags line 1 utc: expects bool, got "maybe"`}, report.Failures())
+}
+
+func TestVerifyTargets(t *testing.T) {
+	targets := VerifyTargets([]VerifyTarget{
+		{Platform: "ubuntu", Version: "5.10.2"},
+		{Platform: "ubuntu", Version: "5.9.1"},
+		{Platform: "darwin", Version: "5.9.1"},
+		{Platform: "ubuntu", Version: "5.9.1"},
+		{Platform: "windows", Version: ""},
+	})
+	assert.Equal(t, []VerifyTarget{
+		{Platform: "darwin", Version: "5.9.1"},
+		{Platform: "ubuntu", Version: "5.9.1"},
+		{Platform: "ubuntu", Version: "5.10.2"},
+	}, targets)
+}
+
+func TestFleetTargets(t *testing.T) {
+	env := TLSEnvironment{OsqueryPins: "darwin=5.10.2"}
+	targets := FleetTargets(env, []VerifyTarget{{Platform: "darwin", Version: "5.9.1"}, {Platform: "windows", Version: "5.9.1"}})
+	assert.Equal(t, []VerifyTarget{
+		{Platform: "darwin", Version: "5.9.1"},
+		{Platform: "darwin", Version: "5.10.2"},
+		{Platform: "windows", Version: "5.9.1"},
+	}, targets)
+}
+
+func TestSelectTargets(t *testing.T) {
+	env := TLSEnvironment{OsqueryPin: "5.10.2"}
+	fleet := []VerifyTarget{{Platform: "darwin", Version: "5.9.1"}, {Platform: "ubuntu", Version: "5.8.2"}, {Platform: "ubuntu", Version: "5.9.1"}}
+	assert.Len(t, SelectTargets(env, fleet, "", ""), 5)
+	assert.Equal(t, []VerifyTarget{{Platform: "darwin", Version: "5.9.1"}, {Platform: "darwin", Version: "5.10.2"}}, SelectTargets(env, fleet, "darwin", ""))
+	assert.Equal(t, []VerifyTarget{{Platform: "darwin", Version: "5.4.0"}, {Platform: "ubuntu", Version: "5.4.0"}}, SelectTargets(env, fleet, "", "5.4.0"))
+	assert.Equal(t, []VerifyTarget{{Platform: "windows", Version: "5.4.0"}}, SelectTargets(env, fleet, "windows", "5.4.0"))
+}
+
+func TestParseOsquerydOutput(t *testing.T) {
+	issues := ParseOsquerydOutput("I0308 starting\nERROR: unknown command line flag 'enable_bpf_event'\n")
+	assert.Len(t, issues, 1)
+	assert.Equal(t, "enable_bpf_event", issues[0].Name)
+	assert.Empty(t, ParseOsquerydOutput("I0308 all good\n"))
+	assert.Nil(t, NewOsquerydChecker(" "))
+	assert.Equal(t, DefaultOsquerydTimeout, NewOsquerydChecker("/usr/bin/osqueryd").Timeout)
+}
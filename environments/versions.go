@@ -0,0 +1,240 @@
+package environments
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// ConfigSections to list the parts of the configuration of an environment kept in each version, in display order
+var ConfigSections = []string{
+	ActivityOptions,
+	ActivitySchedule,
+	ActivityPacks,
+	ActivityDecorators,
+	ActivityATC,
+	ActivityFlags,
+	ActivityConfiguration,
+}
+
+// ConfigVersion to keep an immutable version of the configuration of an environment
+type ConfigVersion struct {
+	gorm.Model
+	EnvironmentID uint `gorm:"index"`
+	Version       int
+	Author        string
+	Note          string
+	Options       string
+	Schedule      string
+	Packs         string
+	Decorators    string
+	ATC           string
+	Flags         string
+	Configuration string
+	Impact        string
+}
+
+// DiffRow to hold one row of a side by side diff, with the line before on the left and after on the right.
+// Empty Op means there is no line on that side.
+type DiffRow struct {
+	Left  DiffLine `json:"left"`
+	Right DiffLine `json:"right"`
+}
+
+// VersionDiff to hold the differences of one section of the configuration between two versions
+type VersionDiff struct {
+	Section string    `json:"section"`
+	Changed bool      `json:"changed"`
+	Rows    []DiffRow `json:"rows"`
+}
+
+// IsConfigKind to check if a kind of activity changes the configuration and creates a version
+func IsConfigKind(kind string) bool {
+	for _, s := range ConfigSections {
+		if s == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// Section to get the value of one section of the configuration in a version
+func (v ConfigVersion) Section(section string) string {
+	switch section {
+	case ActivityOptions:
+		return v.Options
+	case ActivitySchedule:
+		return v.Schedule
+	case ActivityPacks:
+		return v.Packs
+	case ActivityDecorators:
+		return v.Decorators
+	case ActivityATC:
+		return v.ATC
+	case ActivityFlags:
+		return v.Flags
+	case ActivityConfiguration:
+		return v.Configuration
+	}
+	return ""
+}
+
+// Helper to get the configuration of an environment as a version, without number
+func versionOf(env TLSEnvironment) ConfigVersion {
+	return ConfigVersion{
+		EnvironmentID: env.ID,
+		Options:       env.Options,
+		Schedule:      env.Schedule,
+		Packs:         env.Packs,
+		Decorators:    env.Decorators,
+		ATC:           env.ATC,
+		Flags:         env.Flags,
+		Configuration: env.Configuration,
+	}
+}
+
+// Helper to check if two versions have the same configuration
+func sameConfig(a, b ConfigVersion) bool {
+	for _, s := range ConfigSections {
+		if a.Section(s) != b.Section(s) {
+			return false
+		}
+	}
+	return true
+}
+
+// SideBySide to arrange the lines of a diff in rows, pairing removed lines with the added lines that follow
+func SideBySide(diff []DiffLine) []DiffRow {
+	var rows []DiffRow
+	for i := 0; i < len(diff); {
+		if diff[i].Op == " " {
+			rows = append(rows, DiffRow{Left: diff[i], Right: diff[i]})
+			i++
+			continue
+		}
+		var removed, added []DiffLine
+		for ; i < len(diff) && diff[i].Op == "-"; i++ {
+			removed = append(removed, diff[i])
+		}
+		for ; i < len(diff) && diff[i].Op == "+"; i++ {
+			added = append(added, diff[i])
+		}
+		for j := 0; j < len(removed) || j < len(added); j++ {
+			var row DiffRow
+			if j < len(removed) {
+				row.Left = removed[j]
+			}
+			if j < len(added) {
+				row.Right = added[j]
+			}
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// DiffVersions to get the side by side differences of each section of the configuration between two versions
+func DiffVersions(from, to ConfigVersion) []VersionDiff {
+	var diffs []VersionDiff
+	for _, s := range ConfigSections {
+		diffs = append(diffs, VersionDiff{
+			Section: s,
+			Changed: from.Section(s) != to.Section(s),
+			Rows:    SideBySide(DiffLines(from.Section(s), to.Section(s))),
+		})
+	}
+	return diffs
+}
+
+// RecordConfigVersion to add a new version with the current configuration of an environment, if it changed.
+// The configuration before the first version is kept as version 1, so changes can always be rolled back.
+// The impact of the change, when it was calculated, is kept with the new version.
+func (environment *Environment) RecordConfigVersion(before, after TLSEnvironment, author, note, impact string) (ConfigVersion, error) {
+	var version ConfigVersion
+	err := environment.DB.Transaction(func(tx *gorm.DB) error {
+		var latest ConfigVersion
+		if err := tx.Where("environment_id = ?", after.ID).Order("version DESC").Limit(1).Find(&latest).Error; err != nil {
+			return fmt.Errorf("Find %v", err)
+		}
+		if latest.Version == 0 {
+			latest = versionOf(before)
+			latest.Version = 1
+			latest.Note = "configuration before versioning"
+			if err := tx.Create(&latest).Error; err != nil {
+				return fmt.Errorf("Create %v", err)
+			}
+		}
+		version = versionOf(after)
+		if sameConfig(latest, version) {
+			version = latest
+		} else {
+			version.Version = latest.Version + 1
+			version.Author = author
+			version.Note = note
+			version.Impact = impact
+			if err := tx.Create(&version).Error; err != nil {
+				return fmt.Errorf("Create %v", err)
+			}
+		}
+		if err := tx.Model(&TLSEnvironment{}).Where("id = ?", after.ID).Update("config_version", version.Version).Error; err != nil {
+			return fmt.Errorf("Update %v", err)
+		}
+		return nil
+	})
+	return version, err
+}
+
+// ConfigVersions to get all the configuration versions of an environment, newest first
+func (environment *Environment) ConfigVersions(envid uint) ([]ConfigVersion, error) {
+	var versions []ConfigVersion
+	if err := environment.read().Where("environment_id = ?", envid).Order("version DESC").Find(&versions).Error; err != nil {
+		return versions, err
+	}
+	return versions, nil
+}
+
+// GetConfigVersion to get one configuration version of an environment
+func (environment *Environment) GetConfigVersion(envid uint, version int) (ConfigVersion, error) {
+	var v ConfigVersion
+	if err := environment.read().Where("environment_id = ? AND version = ?", envid, version).First(&v).Error; err != nil {
+		return v, err
+	}
+	return v, nil
+}
+
+// RollbackConfig to restore the configuration of an old version, recorded as a new version and activity.
+// Versions are never modified.
+func (environment *Environment) RollbackConfig(idEnv string, version int, author, note string) (ConfigVersion, error) {
+	env, err := environment.Get(idEnv)
+	if err != nil {
+		return ConfigVersion{}, err
+	}
+	old, err := environment.GetConfigVersion(env.ID, version)
+	if err != nil {
+		return old, fmt.Errorf("version %d not found", version)
+	}
+	err = environment.DB.Model(&TLSEnvironment{}).Where("id = ?", env.ID).Updates(map[string]interface{}{
+		"options":       old.Options,
+		"schedule":      old.Schedule,
+		"packs":         old.Packs,
+		"decorators":    old.Decorators,
+		"atc":           old.ATC,
+		"flags":         old.Flags,
+		"configuration": old.Configuration,
+	}).Error
+	if err != nil {
+		return old, fmt.Errorf("Updates %v", err)
+	}
+	if note == "" {
+		note = fmt.Sprintf("rollback to version %d", version)
+	}
+	// The activity records the change and the new version
+	if err := environment.RecordChange(env, ActivityConfiguration, author, note); err != nil {
+		return old, err
+	}
+	var after ConfigVersion
+	if err := environment.DB.Where("environment_id = ?", env.ID).Order("version DESC").Limit(1).Find(&after).Error; err != nil {
+		return after, fmt.Errorf("Find %v", err)
+	}
+	return after, nil
+}
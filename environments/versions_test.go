@@ -0,0 +1,44 @@
+package environments
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSideBySide(t *testing.T) {
+	rows := SideBySide(DiffLines("a\nb\nc\nd", "a\nB\nc\nd\ne"))
+	assert.Equal(t, []DiffRow{
+		{Left: DiffLine{Op: " ", Text: "a"}, Right: DiffLine{Op: " ", Text: "a"}},
+		{Left: DiffLine{Op: "-", Text: "b"}, Right: DiffLine{Op: "+", Text: "B"}},
+		{Left: DiffLine{Op: " ", Text: "c"}, Right: DiffLine{Op: " ", Text: "c"}},
+		{Left: DiffLine{Op: " ", Text: "d"}, Right: DiffLine{Op: " ", Text: "d"}},
+		{Right: DiffLine{Op: "+", Text: "e"}},
+	}, rows)
+	// More lines removed than added leave the right side empty
+	rows = SideBySide(DiffLines("a\nb\nc", "x"))
+	assert.Equal(t, []DiffRow{
+		{Left: DiffLine{Op: "-", Text: "a"}, Right: DiffLine{Op: "+", Text: "x"}},
+		{Left: DiffLine{Op: "-", Text: "b"}},
+		{Left: DiffLine{Op: "-", Text: "c"}},
+	}, rows)
+	assert.Empty(t, SideBySide(nil))
+}
+
+func TestDiffVersions(t *testing.T) {
+	from := ConfigVersion{Options: `{"a": 1}`, Schedule: "{}", Flags: "--verbose"}
+	to := ConfigVersion{Options: `{"a": 2}`, Schedule: "{}", Flags: "--verbose"}
+	diffs := DiffVersions(from, to)
+	assert.Len(t, diffs, len(ConfigSections))
+	for _, d := range diffs {
+		assert.Equal(t, d.Section == ActivityOptions, d.Changed, d.Section)
+	}
+	assert.True(t, sameConfig(from, ConfigVersion{Options: `{"a": 1}`, Schedule: "{}", Flags: "--verbose", Note: "other"}))
+	assert.False(t, sameConfig(from, to))
+}
+
+func TestIsConfigKind(t *testing.T) {
+	assert.True(t, IsConfigKind(ActivityOptions))
+	assert.True(t, IsConfigKind(ActivityFlags))
+	assert.False(t, IsConfigKind(ActivityStorm))
+}
@@ -0,0 +1,350 @@
+package environments
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// WindowsEventsPlatform is the only platform that gets the flags and queries of Windows event channels
+	WindowsEventsPlatform = "windows"
+	// WindowsEventsQueryPrefix for the names of the scheduled queries generated for channels
+	WindowsEventsQueryPrefix = "windows_events_"
+	// DefaultWindowsEventsInterval for channels without interval, in seconds
+	DefaultWindowsEventsInterval = 3600
+	// Columns of the windows_events table returned by the generated queries
+	windowsEventsColumns = "datetime, source, provider_name, provider_guid, computer_name, eventid, task, level, keywords, data"
+)
+
+// Valid names for Windows event channels, commas and quotes would break the flag and the query
+var windowsChannelRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9 _./-]{0,254}$`)
+
+// Characters replaced in the names of the generated queries
+var windowsQueryNameRegexp = regexp.MustCompile(`[^a-z0-9]+`)
+
+// HighVolumeWindowsChannels are channels that generate too many events to collect without interval and row cap
+var HighVolumeWindowsChannels = []string{
+	"Security",
+	"Microsoft-Windows-Sysmon/Operational",
+}
+
+// WindowsEventChannel to define the collection of one Windows event channel in an environment. Enabled channels
+// generate the --windows_event_channels flag and one scheduled query against windows_events for Windows nodes.
+// Interval 0 uses the default interval and MaxRows 0 returns all events.
+type WindowsEventChannel struct {
+	gorm.Model
+	EnvironmentID uint `gorm:"index"`
+	Channel       string
+	Enabled       bool
+	Interval      int
+	MaxRows       int
+}
+
+// WindowsEventSetting to export and import the settings of one channel between environments
+type WindowsEventSetting struct {
+	Channel  string `json:"channel"`
+	Enabled  bool   `json:"enabled"`
+	Interval int    `json:"interval"`
+	MaxRows  int    `json:"max_rows"`
+}
+
+// WindowsEventsPreview to show the channels of an environment with the flags, queries and warnings they generate
+type WindowsEventsPreview struct {
+	Channels []WindowsEventChannel `json:"channels"`
+	Flags    string                `json:"flags"`
+	Schedule ScheduleConf          `json:"schedule"`
+	Warnings []string              `json:"warnings"`
+}
+
+// WindowsEventsQueryName to get the name of the scheduled query generated for a channel
+func WindowsEventsQueryName(channel string) string {
+	return WindowsEventsQueryPrefix + strings.Trim(windowsQueryNameRegexp.ReplaceAllString(strings.ToLower(channel), "_"), "_")
+}
+
+// ValidateWindowsEventChannel to check the name and the limits of a channel
+func ValidateWindowsEventChannel(channel WindowsEventChannel) error {
+	if !windowsChannelRegexp.MatchString(channel.Channel) {
+		return fmt.Errorf("invalid channel %s, use letters, digits, spaces, dashes, dots, slashes and underscores", channel.Channel)
+	}
+	if channel.Interval < 0 || channel.MaxRows < 0 {
+		return fmt.Errorf("invalid interval or rows for channel %s", channel.Channel)
+	}
+	return nil
+}
+
+// ValidateWindowsEvents to check all the channels of an environment, including that their queries do not collide
+func ValidateWindowsEvents(channels []WindowsEventChannel) error {
+	names := make(map[string]string)
+	for _, c := range channels {
+		if err := ValidateWindowsEventChannel(c); err != nil {
+			return err
+		}
+		name := WindowsEventsQueryName(c.Channel)
+		if other, ok := names[name]; ok {
+			return fmt.Errorf("channels %s and %s generate the same query %s", other, c.Channel, name)
+		}
+		names[name] = c.Channel
+	}
+	return nil
+}
+
+// IsHighVolumeWindowsChannel to check if a channel is known for generating many events
+func IsHighVolumeWindowsChannel(channel string) bool {
+	for _, c := range HighVolumeWindowsChannels {
+		if strings.EqualFold(c, channel) {
+			return true
+		}
+	}
+	return false
+}
+
+// WindowsEventsWarnings to get the warnings for enabled high volume channels without interval or row cap
+func WindowsEventsWarnings(channels []WindowsEventChannel) []string {
+	var warnings []string
+	for _, c := range channels {
+		if !c.Enabled || !IsHighVolumeWindowsChannel(c.Channel) {
+			continue
+		}
+		if c.Interval == 0 {
+			warnings = append(warnings, fmt.Sprintf("%s is a high volume channel without interval, the default of %d seconds is used", c.Channel, DefaultWindowsEventsInterval))
+		}
+		if c.MaxRows == 0 {
+			warnings = append(warnings, fmt.Sprintf("%s is a high volume channel without row cap, every event is sent in each interval", c.Channel))
+		}
+	}
+	return warnings
+}
+
+// Helper to get the enabled channels sorted by name, so the flag and the queries are always the same
+func enabledWindowsChannels(channels []WindowsEventChannel) []WindowsEventChannel {
+	var enabled []WindowsEventChannel
+	for _, c := range channels {
+		if c.Enabled {
+			enabled = append(enabled, c)
+		}
+	}
+	sort.SliceStable(enabled, func(i, j int) bool {
+		return enabled[i].Channel < enabled[j].Channel
+	})
+	return enabled
+}
+
+// WindowsEventsFlags to generate the flags that subscribe osquery to the enabled channels, empty if there are none
+func WindowsEventsFlags(channels []WindowsEventChannel) string {
+	enabled := enabledWindowsChannels(channels)
+	if len(enabled) == 0 {
+		return ""
+	}
+	names := make([]string, len(enabled))
+	for i, c := range enabled {
+		names[i] = c.Channel
+	}
+	return "--enable_windows_events_publisher=true\n--enable_windows_events_subscriber=true\n--windows_event_channels=" + strings.Join(names, ",")
+}
+
+// AppendWindowsEventsFlags to add the flags of the enabled channels to the flags of an environment
+func AppendWindowsEventsFlags(flags string, channels []WindowsEventChannel) string {
+	winFlags := WindowsEventsFlags(channels)
+	if winFlags == "" {
+		return flags
+	}
+	return strings.TrimRight(flags, "\n") + "\n" + winFlags + "\n"
+}
+
+// WindowsEventsSchedule to generate one scheduled query for each enabled channel, only for Windows nodes
+func WindowsEventsSchedule(channels []WindowsEventChannel) ScheduleConf {
+	schedule := make(ScheduleConf)
+	for _, c := range enabledWindowsChannels(channels) {
+		interval := c.Interval
+		if interval == 0 {
+			interval = DefaultWindowsEventsInterval
+		}
+		query := fmt.Sprintf("SELECT %s FROM windows_events WHERE source = '%s'", windowsEventsColumns, c.Channel)
+		if c.MaxRows > 0 {
+			query += " LIMIT " + strconv.Itoa(c.MaxRows)
+		}
+		schedule[WindowsEventsQueryName(c.Channel)] = ScheduleQuery{
+			Query:    query + ";",
+			Interval: json.Number(strconv.Itoa(interval)),
+			Platform: WindowsEventsPlatform,
+		}
+	}
+	return schedule
+}
+
+// ApplyWindowsEvents to add the scheduled queries of the enabled channels to a serialized configuration
+func ApplyWindowsEvents(configuration []byte, channels []WindowsEventChannel) ([]byte, error) {
+	generated := WindowsEventsSchedule(channels)
+	if len(generated) == 0 {
+		return configuration, nil
+	}
+	var conf map[string]json.RawMessage
+	if err := json.Unmarshal(configuration, &conf); err != nil {
+		return configuration, fmt.Errorf("Unmarshal %v", err)
+	}
+	schedule := make(map[string]interface{})
+	if raw, ok := conf["schedule"]; ok {
+		if err := json.Unmarshal(raw, &schedule); err != nil {
+			return configuration, fmt.Errorf("Unmarshal schedule %v", err)
+		}
+		if schedule == nil {
+			schedule = make(map[string]interface{})
+		}
+	}
+	for name, q := range generated {
+		schedule[name] = q
+	}
+	var err error
+	if conf["schedule"], err = json.Marshal(schedule); err != nil {
+		return configuration, fmt.Errorf("Marshal schedule %v", err)
+	}
+	res, err := json.MarshalIndent(conf, "", "  ")
+	if err != nil {
+		return configuration, fmt.Errorf("Marshal %v", err)
+	}
+	return res, nil
+}
+
+// PreviewWindowsEvents to get what the channels generate for Windows nodes
+func PreviewWindowsEvents(channels []WindowsEventChannel) WindowsEventsPreview {
+	return WindowsEventsPreview{
+		Channels: channels,
+		Flags:    WindowsEventsFlags(channels),
+		Schedule: WindowsEventsSchedule(channels),
+		Warnings: WindowsEventsWarnings(channels),
+	}
+}
+
+// ExportWindowsEvents to get the settings of the channels, to import them in another environment
+func ExportWindowsEvents(channels []WindowsEventChannel) []WindowsEventSetting {
+	settings := make([]WindowsEventSetting, len(channels))
+	for i, c := range channels {
+		settings[i] = WindowsEventSetting{
+			Channel:  c.Channel,
+			Enabled:  c.Enabled,
+			Interval: c.Interval,
+			MaxRows:  c.MaxRows,
+		}
+	}
+	return settings
+}
+
+// SerializeWindowsEvents to get the exported settings of the channels as JSON, used for the activity
+func SerializeWindowsEvents(channels []WindowsEventChannel) string {
+	raw, err := json.MarshalIndent(ExportWindowsEvents(channels), "", "  ")
+	if err != nil {
+		return ""
+	}
+	return string(raw)
+}
+
+// WindowsEvents to get the Windows event channels of an environment, sorted by name
+func (environment *Environment) WindowsEvents(envid uint) ([]WindowsEventChannel, error) {
+	return environment.WindowsEventsCtx(context.Background(), envid)
+}
+
+// WindowsEventsCtx to get the Windows event channels of an environment, sorted by name
+func (environment *Environment) WindowsEventsCtx(ctx context.Context, envid uint) ([]WindowsEventChannel, error) {
+	var channels []WindowsEventChannel
+	if err := environment.read().WithContext(ctx).Where("environment_id = ?", envid).Order("channel").Find(&channels).Error; err != nil {
+		return channels, err
+	}
+	return channels, nil
+}
+
+// SetWindowsEventChannel to create or update a Windows event channel of an environment, by name. Disabling a
+// channel removes its flag and its query at once, since both are generated from the same row.
+func (environment *Environment) SetWindowsEventChannel(envid uint, channel WindowsEventChannel) (WindowsEventChannel, error) {
+	channel.Channel = strings.TrimSpace(channel.Channel)
+	if err := ValidateWindowsEventChannel(channel); err != nil {
+		return channel, err
+	}
+	current, err := environment.WindowsEvents(envid)
+	if err != nil {
+		return channel, fmt.Errorf("WindowsEvents %v", err)
+	}
+	var existing *WindowsEventChannel
+	others := []WindowsEventChannel{channel}
+	for i, c := range current {
+		if c.Channel == channel.Channel {
+			existing = &current[i]
+			continue
+		}
+		others = append(others, c)
+	}
+	if err := ValidateWindowsEvents(others); err != nil {
+		return channel, err
+	}
+	if existing == nil {
+		channel.EnvironmentID = envid
+		if err := environment.DB.Create(&channel).Error; err != nil {
+			return channel, fmt.Errorf("Create %v", err)
+		}
+		return channel, nil
+	}
+	err = environment.DB.Model(existing).Updates(map[string]interface{}{
+		"enabled":  channel.Enabled,
+		"interval": channel.Interval,
+		"max_rows": channel.MaxRows,
+	}).Error
+	if err != nil {
+		return *existing, fmt.Errorf("Updates %v", err)
+	}
+	existing.Enabled, existing.Interval, existing.MaxRows = channel.Enabled, channel.Interval, channel.MaxRows
+	return *existing, nil
+}
+
+// DeleteWindowsEventChannel to delete a Windows event channel of an environment, by name
+func (environment *Environment) DeleteWindowsEventChannel(envid uint, channel string) error {
+	res := environment.DB.Unscoped().Where("environment_id = ? AND channel = ?", envid, strings.TrimSpace(channel)).Delete(&WindowsEventChannel{})
+	if res.Error != nil {
+		return fmt.Errorf("Delete %v", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// ImportWindowsEvents to replace all the Windows event channels of an environment with exported settings
+func (environment *Environment) ImportWindowsEvents(envid uint, settings []WindowsEventSetting) ([]WindowsEventChannel, error) {
+	channels := make([]WindowsEventChannel, len(settings))
+	for i, s := range settings {
+		channels[i] = WindowsEventChannel{
+			EnvironmentID: envid,
+			Channel:       strings.TrimSpace(s.Channel),
+			Enabled:       s.Enabled,
+			Interval:      s.Interval,
+			MaxRows:       s.MaxRows,
+		}
+	}
+	if err := ValidateWindowsEvents(channels); err != nil {
+		return nil, err
+	}
+	err := environment.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("environment_id = ?", envid).Delete(&WindowsEventChannel{}).Error; err != nil {
+			return fmt.Errorf("Delete %v", err)
+		}
+		if len(channels) == 0 {
+			return nil
+		}
+		if err := tx.Create(&channels).Error; err != nil {
+			return fmt.Errorf("Create %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(channels, func(i, j int) bool {
+		return channels[i].Channel < channels[j].Channel
+	})
+	return channels, nil
+}
@@ -0,0 +1,73 @@
+package environments
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testWindowsChannels = []WindowsEventChannel{
+	{Channel: "Security", Enabled: true, Interval: 600, MaxRows: 500},
+	{Channel: "Microsoft-Windows-Sysmon/Operational", Enabled: true},
+	{Channel: "Microsoft-Windows-PowerShell/Operational", Enabled: false, Interval: 300},
+}
+
+func TestWindowsEventsQueryName(t *testing.T) {
+	assert.Equal(t, "windows_events_security", WindowsEventsQueryName("Security"))
+	assert.Equal(t, "windows_events_microsoft_windows_sysmon_operational", WindowsEventsQueryName("Microsoft-Windows-Sysmon/Operational"))
+	assert.Equal(t, "windows_events_windows_powershell", WindowsEventsQueryName("Windows PowerShell"))
+}
+
+func TestValidateWindowsEvents(t *testing.T) {
+	assert.NoError(t, ValidateWindowsEvents(testWindowsChannels))
+	assert.Error(t, ValidateWindowsEventChannel(WindowsEventChannel{Channel: "Security' OR 1=1"}))
+	assert.Error(t, ValidateWindowsEventChannel(WindowsEventChannel{Channel: "System,Security"}))
+	assert.Error(t, ValidateWindowsEventChannel(WindowsEventChannel{Channel: "System", Interval: -1}))
+	assert.Error(t, ValidateWindowsEvents([]WindowsEventChannel{{Channel: "Windows PowerShell"}, {Channel: "Windows-PowerShell"}}))
+}
+
+func TestWindowsEventsWarnings(t *testing.T) {
+	warnings := WindowsEventsWarnings(testWindowsChannels)
+	assert.Equal(t, 2, len(warnings))
+	assert.Contains(t, warnings[0], "Microsoft-Windows-Sysmon/Operational")
+	assert.Equal(t, 0, len(WindowsEventsWarnings([]WindowsEventChannel{{Channel: "Security", Enabled: false}})))
+}
+
+func TestWindowsEventsFlags(t *testing.T) {
+	flags := WindowsEventsFlags(testWindowsChannels)
+	assert.Contains(t, flags, "--enable_windows_events_publisher=true\n")
+	assert.Contains(t, flags, "--windows_event_channels=Microsoft-Windows-Sysmon/Operational,Security")
+	assert.NotContains(t, flags, "PowerShell")
+	assert.Equal(t, "", WindowsEventsFlags(nil))
+	assert.Equal(t, "--utc=true\n", AppendWindowsEventsFlags("--utc=true\n", nil))
+	assert.Equal(t, "--utc=true\n"+flags+"\n", AppendWindowsEventsFlags("--utc=true\n", testWindowsChannels))
+}
+
+func TestApplyWindowsEvents(t *testing.T) {
+	conf := []byte(`{"options":{"utc":true},"schedule":{"uptime":{"query":"SELECT * FROM uptime;","interval":60}}}`)
+	res, err := ApplyWindowsEvents(conf, testWindowsChannels)
+	assert.NoError(t, err)
+	var parsed OsqueryConf
+	assert.NoError(t, json.Unmarshal(res, &parsed))
+	assert.Equal(t, 3, len(parsed.Schedule))
+	assert.Equal(t, true, parsed.Options["utc"])
+	security := parsed.Schedule["windows_events_security"]
+	assert.Equal(t, "windows", security.Platform)
+	assert.Equal(t, json.Number("600"), security.Interval)
+	assert.Contains(t, security.Query, "WHERE source = 'Security' LIMIT 500;")
+	sysmon := parsed.Schedule["windows_events_microsoft_windows_sysmon_operational"]
+	assert.Equal(t, json.Number("3600"), sysmon.Interval)
+	assert.NotContains(t, sysmon.Query, "LIMIT")
+	// Without enabled channels the configuration is the same
+	res, err = ApplyWindowsEvents(conf, testWindowsChannels[2:])
+	assert.NoError(t, err)
+	assert.Equal(t, conf, res)
+}
+
+func TestExportWindowsEvents(t *testing.T) {
+	settings := ExportWindowsEvents(testWindowsChannels)
+	assert.Equal(t, 3, len(settings))
+	assert.Equal(t, WindowsEventSetting{Channel: "Security", Enabled: true, Interval: 600, MaxRows: 500}, settings[0])
+	assert.Contains(t, SerializeWindowsEvents(testWindowsChannels), `"max_rows": 500`)
+}
@@ -12,12 +12,16 @@ replace github.com/jmpsec/osctrl/api/handlers => ./api/handlers
 
 replace github.com/jmpsec/osctrl/backend => ./backend
 
+replace github.com/jmpsec/osctrl/backup => ./backup
+
 replace github.com/jmpsec/osctrl/cache => ./cache
 
 replace github.com/jmpsec/osctrl/carves => ./carves
 
 replace github.com/jmpsec/osctrl/environments => ./environments
 
+replace github.com/jmpsec/osctrl/locales => ./locales
+
 replace github.com/jmpsec/osctrl/logging => ./logging
 
 replace github.com/jmpsec/osctrl/metrics => ./metrics
@@ -26,6 +30,10 @@ replace github.com/jmpsec/osctrl/nodes => ./nodes
 
 replace github.com/jmpsec/osctrl/queries => ./queries
 
+replace github.com/jmpsec/osctrl/reports => ./reports
+
+replace github.com/jmpsec/osctrl/responses => ./responses
+
 replace github.com/jmpsec/osctrl/settings => ./settings
 
 replace github.com/jmpsec/osctrl/tags => ./tags
@@ -40,20 +48,27 @@ replace github.com/jmpsec/osctrl/utils => ./utils
 
 replace github.com/jmpsec/osctrl/version => ./version
 
+replace github.com/jmpsec/osctrl/webhooks => ./webhooks
+
 require (
+	github.com/DATA-DOG/go-sqlmock v1.5.0
 	github.com/crewjam/saml v0.4.9
 	github.com/golang-jwt/jwt/v4 v4.4.2
 	github.com/gorilla/mux v1.8.0
 	github.com/jmpsec/osctrl/admin/handlers v0.3.1
 	github.com/jmpsec/osctrl/admin/sessions v0.3.1
 	github.com/jmpsec/osctrl/backend v0.3.1
+	github.com/jmpsec/osctrl/backup v0.3.1
 	github.com/jmpsec/osctrl/cache v0.3.1
 	github.com/jmpsec/osctrl/carves v0.3.1
 	github.com/jmpsec/osctrl/environments v0.3.1
+	github.com/jmpsec/osctrl/locales v0.3.1
 	github.com/jmpsec/osctrl/logging v0.3.1
 	github.com/jmpsec/osctrl/metrics v0.3.1
 	github.com/jmpsec/osctrl/nodes v0.3.1
 	github.com/jmpsec/osctrl/queries v0.3.1
+	github.com/jmpsec/osctrl/reports v0.3.1
+	github.com/jmpsec/osctrl/responses v0.3.1
 	github.com/jmpsec/osctrl/settings v0.3.1
 	github.com/jmpsec/osctrl/tags v0.3.1
 	github.com/jmpsec/osctrl/tls/handlers v0.3.1
@@ -61,13 +76,20 @@ require (
 	github.com/jmpsec/osctrl/users v0.3.1
 	github.com/jmpsec/osctrl/utils v0.3.1
 	github.com/jmpsec/osctrl/version v0.3.1
+	github.com/jmpsec/osctrl/webhooks v0.3.1
 	github.com/olekukonko/tablewriter v0.0.5
 	github.com/spf13/viper v1.13.0
+	github.com/stretchr/testify v1.8.1
 	github.com/urfave/cli/v2 v2.23.0
+	gorm.io/driver/postgres v1.4.6
 	gorm.io/gorm v1.24.3
 )
 
-require github.com/jackc/pgx/v5 v5.2.0 // indirect
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/jackc/pgx/v5 v5.2.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+)
 
 require (
 	github.com/aws/aws-sdk-go v1.42.44 // indirect
@@ -132,5 +154,4 @@ require (
 	gopkg.in/natefinch/lumberjack.v2 v2.0.0 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
-	gorm.io/driver/postgres v1.4.6 // indirect
 )
@@ -0,0 +1,5 @@
+module locales
+
+go 1.17
+
+require github.com/stretchr/testify v1.8.1
@@ -0,0 +1,143 @@
+package locales
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultLocale to fallback for missing locales and keys
+	DefaultLocale = "en"
+	// KeyName for the name of the locale in its own language
+	KeyName = "locale.name"
+	// KeyDate for the layout of dates
+	KeyDate = "format.date"
+	// KeyDateTime for the layout of dates with time
+	KeyDateTime = "format.datetime"
+)
+
+// Catalog to hold the messages of one locale by key
+type Catalog map[string]string
+
+// All the catalogs, one JSON file per locale named after it. Adding a locale only needs a new file.
+//
+//go:embed catalogs/*.json
+var catalogFiles embed.FS
+
+// Catalogs loaded by locale
+var catalogs = loadCatalogs()
+
+// Helper to load all the embedded catalogs
+func loadCatalogs() map[string]Catalog {
+	loaded := make(map[string]Catalog)
+	files, err := catalogFiles.ReadDir("catalogs")
+	if err != nil {
+		log.Fatalf("error reading catalogs %v", err)
+	}
+	for _, f := range files {
+		raw, err := catalogFiles.ReadFile(path.Join("catalogs", f.Name()))
+		if err != nil {
+			log.Fatalf("error reading catalog %s %v", f.Name(), err)
+		}
+		var c Catalog
+		if err := json.Unmarshal(raw, &c); err != nil {
+			log.Fatalf("error parsing catalog %s %v", f.Name(), err)
+		}
+		loaded[strings.TrimSuffix(f.Name(), path.Ext(f.Name()))] = c
+	}
+	return loaded
+}
+
+// Locales to get all the available locales, sorted
+func Locales() []string {
+	var list []string
+	for l := range catalogs {
+		list = append(list, l)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// Names to get the name of each available locale, in its own language
+func Names() map[string]string {
+	names := make(map[string]string)
+	for l := range catalogs {
+		names[l] = T(l, KeyName)
+	}
+	return names
+}
+
+// IsValid to check if a locale is available, empty uses the default
+func IsValid(locale string) bool {
+	if locale == "" {
+		return true
+	}
+	_, ok := catalogs[locale]
+	return ok
+}
+
+// Normalize to get the locale to use, the default one if empty or not available
+func Normalize(locale string) string {
+	if _, ok := catalogs[locale]; ok {
+		return locale
+	}
+	return DefaultLocale
+}
+
+// T to translate a key to a locale, formatting it with the arguments if any.
+// Missing keys fallback to the default locale and then to the key itself.
+func T(locale, key string, args ...interface{}) string {
+	msg, ok := catalogs[Normalize(locale)][key]
+	if !ok {
+		if msg, ok = catalogs[DefaultLocale][key]; !ok {
+			msg = key
+		}
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
+
+// Date to format a date for a locale
+func Date(locale string, t time.Time) string {
+	return t.Format(T(locale, KeyDate))
+}
+
+// DateTime to format a date with time for a locale
+func DateTime(locale string, t time.Time) string {
+	return t.Format(T(locale, KeyDateTime))
+}
+
+// FuncMap to get the template functions to translate and format dates for a locale
+func FuncMap(locale string) map[string]interface{} {
+	return map[string]interface{}{
+		"T": func(key string, args ...interface{}) string {
+			return T(locale, key, args...)
+		},
+		"date": func(t time.Time) string {
+			return Date(locale, t)
+		},
+		"datetime": func(t time.Time) string {
+			return DateTime(locale, t)
+		},
+	}
+}
+
+// Missing to get the keys of the default locale that are missing in a locale
+func Missing(locale string) []string {
+	var missing []string
+	for k := range catalogs[DefaultLocale] {
+		if _, ok := catalogs[locale][k]; !ok {
+			missing = append(missing, k)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
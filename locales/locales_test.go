@@ -0,0 +1,57 @@
+package locales
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Format verbs used in messages
+var verbsRegexp = regexp.MustCompile(`%[a-z]`)
+
+func TestCatalogsComplete(t *testing.T) {
+	assert.Contains(t, Locales(), DefaultLocale)
+	for _, l := range Locales() {
+		assert.Empty(t, Missing(l), "locale %s is missing keys", l)
+		for k, msg := range catalogs[l] {
+			def, ok := catalogs[DefaultLocale][k]
+			assert.True(t, ok, "locale %s has unknown key %s", l, k)
+			assert.NotEmpty(t, msg, "locale %s has empty key %s", l, k)
+			// Arguments must be the same, in the same order
+			assert.Equal(t, verbsRegexp.FindAllString(def, -1), verbsRegexp.FindAllString(msg, -1), "locale %s key %s", l, k)
+			// Messages of scripts are rendered inside quoted strings of shell and powershell
+			if strings.HasPrefix(k, "script.") {
+				assert.False(t, strings.ContainsAny(msg, "\"'`$\\\n"), "locale %s key %s has unsafe characters", l, k)
+			}
+		}
+	}
+}
+
+func TestCatalogsDates(t *testing.T) {
+	day := time.Date(2026, 3, 14, 15, 9, 0, 0, time.UTC)
+	for _, l := range Locales() {
+		parsed, err := time.Parse(T(l, KeyDate), Date(l, day))
+		assert.NoError(t, err, "locale %s", l)
+		assert.Equal(t, day.Truncate(24*time.Hour), parsed, "locale %s", l)
+		assert.Contains(t, DateTime(l, day), "15:09", "locale %s", l)
+	}
+	assert.Equal(t, "14/03/2026", Date("es", day))
+}
+
+func TestT(t *testing.T) {
+	assert.Equal(t, "Expired", T("en", "enroll.expired"))
+	assert.Equal(t, "Expirado", T("es", "enroll.expired"))
+	// Unknown locales use the default
+	assert.Equal(t, "Expired", T("xx", "enroll.expired"))
+	assert.Equal(t, "Expired", T("", "enroll.expired"))
+	// Unknown keys are returned as they are
+	assert.Equal(t, "missing.key", T("es", "missing.key"))
+	assert.Equal(t, "osctrl report for dev", T("en", "report.title", "dev"))
+	assert.True(t, IsValid(""))
+	assert.True(t, IsValid("es"))
+	assert.False(t, IsValid("xx"))
+	assert.Equal(t, "Español", Names()["es"])
+}
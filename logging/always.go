@@ -0,0 +1,247 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+)
+
+const (
+	// AlwaysLogKey is the key in the configuration file for the always logger filters
+	AlwaysLogKey = "always"
+	// DefaultTrafficFlush is the default interval to store the traffic seen by the always logger
+	DefaultTrafficFlush = 60 * time.Second
+	// DefaultTrafficRetention is how long traffic samples are kept for estimations
+	DefaultTrafficRetention = 2 * time.Hour
+)
+
+// DefaultAlwaysTypes are the log types written by the always logger when no types are configured
+var DefaultAlwaysTypes = []string{types.StatusLog, types.QueryLog}
+
+// AlwaysFilters to select which logs are written by the always logger
+type AlwaysFilters struct {
+	// Log types to write (result/status/query), empty means status and query logs
+	Types []string `json:"types"`
+	// Patterns for pack and query names of result logs, empty means all of them
+	Names []string `json:"names"`
+	// Environments where the always logger is disabled
+	DisabledEnvs []string `json:"disabledEnvs"`
+}
+
+// AlwaysLogTraffic to store how many log entries the always logger has seen, matched or not
+type AlwaysLogTraffic struct {
+	gorm.Model
+	LogType     string `gorm:"index"`
+	Environment string
+	Name        string
+	Entries     int64
+}
+
+// AlwaysEstimate to hold how much traffic would have been written with some filters
+type AlwaysEstimate struct {
+	Total   int64   `json:"total"`
+	Matched int64   `json:"matched"`
+	Percent float64 `json:"percent"`
+}
+
+// LoadAlwaysFilters - Function to load the always logger filters from JSON file
+func LoadAlwaysFilters(file string) (AlwaysFilters, error) {
+	var filters AlwaysFilters
+	log.Printf("Loading %s", file)
+	// Load file and read config
+	viper.SetConfigFile(file)
+	if err := viper.ReadInConfig(); err != nil {
+		return filters, err
+	}
+	cfgRaw := viper.Sub(AlwaysLogKey)
+	if cfgRaw == nil {
+		return filters, fmt.Errorf("missing %s in %s", AlwaysLogKey, file)
+	}
+	if err := cfgRaw.Unmarshal(&filters); err != nil {
+		return filters, err
+	}
+	// No errors!
+	return filters, nil
+}
+
+// AlwaysFiltersFromSettings - Function to build the always logger filters from the TLS settings
+func AlwaysFiltersFromSettings(values settings.MapSettings) AlwaysFilters {
+	return AlwaysFilters{
+		Types:        SplitFilter(values[settings.AlwaysLogTypes].String),
+		Names:        SplitFilter(values[settings.AlwaysLogNames].String),
+		DisabledEnvs: SplitFilter(values[settings.AlwaysLogDisabled].String),
+	}
+}
+
+// SplitFilter - Helper to convert a comma separated setting into a list of values
+func SplitFilter(value string) []string {
+	var res []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+// MatchType - Function to check if a log type in an environment must be written
+func (f AlwaysFilters) MatchType(logType, environment string) bool {
+	for _, e := range f.DisabledEnvs {
+		if e == environment {
+			return false
+		}
+	}
+	logTypes := f.Types
+	if len(logTypes) == 0 {
+		logTypes = DefaultAlwaysTypes
+	}
+	for _, t := range logTypes {
+		if t == logType {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchName - Function to check if a pack or query name of a result log must be written
+func (f AlwaysFilters) MatchName(name string) bool {
+	if len(f.Names) == 0 {
+		return true
+	}
+	for _, p := range f.Names {
+		if ok, err := path.Match(p, name); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Match - Function to check if a log must be written, names only apply to result logs
+func (f AlwaysFilters) Match(logType, environment, name string) bool {
+	if !f.MatchType(logType, environment) {
+		return false
+	}
+	if logType == types.ResultLog {
+		return f.MatchName(name)
+	}
+	return true
+}
+
+type trafficKey struct {
+	logType     string
+	environment string
+	name        string
+}
+
+// AlwaysTraffic to count in memory the logs seen by the always logger
+type AlwaysTraffic struct {
+	DB     *gorm.DB
+	mutex  sync.Mutex
+	counts map[trafficKey]int64
+}
+
+// CreateAlwaysTraffic to initialize the traffic counters for the always logger
+func CreateAlwaysTraffic(backend *gorm.DB) (*AlwaysTraffic, error) {
+	if err := backend.AutoMigrate(&AlwaysLogTraffic{}); err != nil {
+		return nil, fmt.Errorf("Failed to AutoMigrate table (always_log_traffic): %v", err)
+	}
+	return &AlwaysTraffic{
+		DB:     backend,
+		counts: make(map[trafficKey]int64),
+	}, nil
+}
+
+// Add - Function to count entries seen by the always logger
+func (t *AlwaysTraffic) Add(logType, environment, name string, entries int64) {
+	if t == nil || entries == 0 {
+		return
+	}
+	// Only result logs are kept by name
+	if logType != types.ResultLog {
+		name = ""
+	}
+	t.mutex.Lock()
+	t.counts[trafficKey{logType: logType, environment: environment, name: name}] += entries
+	t.mutex.Unlock()
+}
+
+// Flush - Function to store the current counters and remove old samples
+func (t *AlwaysTraffic) Flush() error {
+	t.mutex.Lock()
+	counts := t.counts
+	t.counts = make(map[trafficKey]int64)
+	t.mutex.Unlock()
+	for k, v := range counts {
+		entry := AlwaysLogTraffic{
+			LogType:     k.logType,
+			Environment: k.environment,
+			Name:        k.name,
+			Entries:     v,
+		}
+		if err := t.DB.Create(&entry).Error; err != nil {
+			return fmt.Errorf("Create %v", err)
+		}
+	}
+	cutoff := time.Now().Add(-DefaultTrafficRetention)
+	if err := t.DB.Unscoped().Where("created_at < ?", cutoff).Delete(&AlwaysLogTraffic{}).Error; err != nil {
+		return fmt.Errorf("Delete %v", err)
+	}
+	return nil
+}
+
+// Start - Function to flush the counters periodically until the context is done
+func (t *AlwaysTraffic) Start(ctx context.Context, interval time.Duration) {
+	if t == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				if err := t.Flush(); err != nil {
+					log.Printf("error flushing always log traffic %v", err)
+				}
+				return
+			case <-ticker.C:
+				if err := t.Flush(); err != nil {
+					log.Printf("error flushing always log traffic %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// EstimateAlways - Function to calculate how much of the traffic since a time would match the filters
+func EstimateAlways(db *gorm.DB, filters AlwaysFilters, since time.Time) (AlwaysEstimate, error) {
+	var estimate AlwaysEstimate
+	var samples []AlwaysLogTraffic
+	err := db.Model(&AlwaysLogTraffic{}).
+		Select("log_type, environment, name, sum(entries) as entries").
+		Where("created_at >= ?", since).
+		Group("log_type, environment, name").
+		Scan(&samples).Error
+	if err != nil {
+		return estimate, fmt.Errorf("Scan %v", err)
+	}
+	for _, s := range samples {
+		estimate.Total += s.Entries
+		if filters.Match(s.LogType, s.Environment, s.Name) {
+			estimate.Matched += s.Entries
+		}
+	}
+	if estimate.Total > 0 {
+		estimate.Percent = float64(estimate.Matched) * 100 / float64(estimate.Total)
+	}
+	return estimate, nil
+}
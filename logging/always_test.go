@@ -0,0 +1,70 @@
+package logging
+
+import (
+	"testing"
+
+	"github.com/jmpsec/osctrl/types"
+)
+
+func TestAlwaysFiltersDefault(t *testing.T) {
+	f := AlwaysFilters{}
+	if !f.Match(types.StatusLog, "dev", "") {
+		t.Errorf("status logs should match without filters")
+	}
+	if !f.Match(types.QueryLog, "dev", "on-demand") {
+		t.Errorf("query logs should match without filters")
+	}
+	if f.Match(types.ResultLog, "dev", "pack_processes") {
+		t.Errorf("result logs should not match without filters")
+	}
+}
+
+func TestAlwaysFiltersNames(t *testing.T) {
+	f := AlwaysFilters{
+		Types:        []string{types.ResultLog, types.QueryLog},
+		Names:        []string{"pack_critical_*", "users"},
+		DisabledEnvs: []string{"noisy"},
+	}
+	cases := []struct {
+		logType     string
+		environment string
+		name        string
+		expected    bool
+	}{
+		{types.ResultLog, "dev", "pack_critical_usb", true},
+		{types.ResultLog, "dev", "users", true},
+		{types.ResultLog, "dev", "process_events", false},
+		{types.StatusLog, "dev", "", false},
+		// Names do not apply to on-demand queries
+		{types.QueryLog, "dev", "anything", true},
+		{types.ResultLog, "noisy", "users", false},
+	}
+	for _, c := range cases {
+		if f.Match(c.logType, c.environment, c.name) != c.expected {
+			t.Errorf("match %s/%s/%s expected %v", c.logType, c.environment, c.name, c.expected)
+		}
+	}
+}
+
+func TestAlwaysFiltersPaused(t *testing.T) {
+	logTLS := &LoggerTLS{}
+	logTLS.SetAlwaysFilters(AlwaysFilters{DisabledEnvs: []string{"noisy"}})
+	logTLS.SetAlwaysPaused([]string{"full"})
+	f := logTLS.GetAlwaysFilters()
+	if f.Match(types.StatusLog, "full", "") {
+		t.Errorf("logs of paused environments should not match")
+	}
+	if f.Match(types.StatusLog, "noisy", "") {
+		t.Errorf("logs of disabled environments should not match")
+	}
+	if !f.Match(types.StatusLog, "dev", "") {
+		t.Errorf("logs of other environments should match")
+	}
+	logTLS.SetAlwaysPaused(nil)
+	if !logTLS.GetAlwaysFilters().Match(types.StatusLog, "full", "") {
+		t.Errorf("logs should match again once the environment is not paused")
+	}
+	if len(logTLS.alwaysFilters.DisabledEnvs) != 1 {
+		t.Errorf("paused environments should not change the filters")
+	}
+}
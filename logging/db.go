@@ -10,6 +10,7 @@ import (
 	"gorm.io/gorm"
 
 	"github.com/jmpsec/osctrl/backend"
+	"github.com/jmpsec/osctrl/environments"
 	"github.com/jmpsec/osctrl/queries"
 	"github.com/jmpsec/osctrl/settings"
 	"github.com/jmpsec/osctrl/types"
@@ -20,16 +21,63 @@ const (
 	defaultCleanupInterval = 86400
 )
 
-// OsqueryResultData to log result data to database
+// OsqueryResultData to log result data to database, one row for each row of results
 type OsqueryResultData struct {
 	gorm.Model
-	UUID        string `gorm:"index"`
+	UUID         string `gorm:"index"`
+	Environment  string
+	Name         string
+	Format       string
+	EventType    string
+	Action       string
+	Epoch        int64
+	Columns      string
+	Counter      int
+	Numerics     bool
+	UnixTime     int64
+	CalendarTime string
+}
+
+// ResultFilter to select result logs from the database, zero values match everything
+type ResultFilter struct {
 	Environment string
+	UUID        string
 	Name        string
 	Action      string
-	Epoch       int64
-	Columns     string
-	Counter     int
+	Since       time.Time
+	AfterID     uint
+	Limit       int
+}
+
+// Event to get the normalized event of a result log stored in the database
+func (r OsqueryResultData) Event() (types.ResultEvent, error) {
+	columns, err := types.NormalizeColumns(json.RawMessage(r.Columns))
+	if err != nil {
+		return types.ResultEvent{}, fmt.Errorf("invalid columns in %s - %v", r.Name, err)
+	}
+	event := types.ResultEvent{
+		ID:        r.ID,
+		Name:      r.Name,
+		Node:      r.UUID,
+		Format:    r.Format,
+		EventType: r.EventType,
+		Action:    r.Action,
+		Time:      r.CreatedAt.UTC(),
+		Epoch:     r.Epoch,
+		Counter:   int64(r.Counter),
+		Numerics:  r.Numerics,
+		Columns:   columns,
+	}
+	// The time of the node is kept apart, the server time is the one used for ordering and retention
+	event.AgentTime = types.AgentTime(types.StringInt(r.UnixTime), r.CalendarTime)
+	// Logs stored before the event type was recorded
+	if event.EventType == "" {
+		event.EventType = types.EventType(r.Action)
+	}
+	if event.Format == "" {
+		event.Format = types.ResultFormatEvent
+	}
+	return event, nil
 }
 
 // OsqueryStatusData to log status data to database
@@ -58,6 +106,7 @@ type OsqueryQueryData struct {
 type LoggerDB struct {
 	Database *backend.DBManager
 	Enabled  bool
+	Counters *environments.StorageCounters
 }
 
 // CreateLoggerDB to initialize the logger
@@ -139,34 +188,59 @@ func (logDB *LoggerDB) Status(data []byte, environment, uuid string, debug bool)
 		}
 		if err := logDB.Database.Conn.Create(&entry).Error; err != nil {
 			log.Printf("Error creating status log entry %s", err)
+			continue
 		}
+		logDB.Counters.Add(environment, environments.StorageStatus, 1, int64(len(entry.Message)+len(entry.Filename)))
 	}
 }
 
 // Result - Function that sends JSON result logs to the configured DB
 func (logDB *LoggerDB) Result(data []byte, environment, uuid string, debug bool) {
 	// Parse JSON
-	var logs []types.LogResultData
-	if err := json.Unmarshal(data, &logs); err != nil {
+	logs, err := types.ParseResultLogs(data)
+	if err != nil {
 		log.Printf("error parsing logs %s %v", string(data), err)
 	}
+	logDB.ResultEntries(logs, environment)
+}
+
+// ResultEntries - Function that inserts already parsed result logs in the configured DB.
+// Batches and snapshots are stored as one entry for each row, with the format they were sent.
+func (logDB *LoggerDB) ResultEntries(logs []types.LogResultData, environment string) {
 	// Iterate and insert in DB
 	for _, l := range logs {
-		entry := OsqueryResultData{
-			UUID:        strings.ToUpper(l.HostIdentifier),
-			Environment: environment,
-			Name:        l.Name,
-			Action:      l.Action,
-			Epoch:       l.Epoch,
-			Columns:     string(l.Columns),
-			Counter:     l.Counter,
-		}
-		if err := logDB.Database.Conn.Create(&entry).Error; err != nil {
-			log.Printf("Error creating result log entry %s", err)
+		for _, entry := range resultEntries(l, strings.ToUpper(l.HostIdentifier), environment) {
+			if err := logDB.Database.Conn.Create(&entry).Error; err != nil {
+				log.Printf("Error creating result log entry %s", err)
+				continue
+			}
+			logDB.Counters.Add(environment, environments.StorageResults, 1, int64(len(entry.Columns)+len(entry.Name)))
 		}
 	}
 }
 
+// Helper to prepare the entries of one result log of a node, one for each row
+func resultEntries(l types.LogResultData, uuid, environment string) []OsqueryResultData {
+	var entries []OsqueryResultData
+	for _, r := range l.Rows() {
+		entries = append(entries, OsqueryResultData{
+			UUID:         uuid,
+			Environment:  environment,
+			Name:         l.Name,
+			Format:       l.Format(),
+			EventType:    types.EventType(r.Action),
+			Action:       r.Action,
+			Epoch:        int64(l.Epoch),
+			Columns:      string(r.Columns),
+			Counter:      int(l.Counter),
+			Numerics:     l.Numerics,
+			UnixTime:     int64(l.UnixTime),
+			CalendarTime: l.CalendarTime,
+		})
+	}
+	return entries
+}
+
 // Query - Function that sends JSON query logs to the configured DB
 func (logDB *LoggerDB) Query(data []byte, environment, uuid, name string, status int, debug bool) {
 	// Prepare data
@@ -177,10 +251,18 @@ func (logDB *LoggerDB) Query(data []byte, environment, uuid, name string, status
 		Data:        string(data),
 		Status:      status,
 	}
-	// Insert in DB
-	if err := logDB.Database.Conn.Create(&entry).Error; err != nil {
+	// Replace the previous result of the node for the query, so results sent again are not appended
+	err := logDB.Database.Conn.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("uuid = ? AND environment = ? AND name = ?", entry.UUID, environment, name).Delete(&OsqueryQueryData{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&entry).Error
+	})
+	if err != nil {
 		log.Printf("Error creating query log %s", err)
+		return
 	}
+	logDB.Counters.Add(environment, environments.StorageQueries, 1, int64(len(entry.Data)))
 }
 
 // QueryLogs will retrieve all query logs
@@ -212,7 +294,35 @@ func (logDB *LoggerDB) ResultLogs(uuid, environment string, seconds int64) ([]Os
 	return logs, nil
 }
 
-// CleanStatusLogs will delete old status logs
+// FilterResultLogs will retrieve result logs matching a filter, oldest first
+func (logDB *LoggerDB) FilterResultLogs(filter ResultFilter) ([]OsqueryResultData, error) {
+	var logs []OsqueryResultData
+	db := logDB.Database.Conn.Where("environment = ?", filter.Environment)
+	if filter.UUID != "" {
+		db = db.Where("uuid = ?", strings.ToUpper(filter.UUID))
+	}
+	if filter.Name != "" {
+		db = db.Where("name = ?", filter.Name)
+	}
+	if filter.Action != "" {
+		db = db.Where("action = ?", filter.Action)
+	}
+	if !filter.Since.IsZero() {
+		db = db.Where("created_at > ?", filter.Since)
+	}
+	if filter.AfterID > 0 {
+		db = db.Where("id > ?", filter.AfterID)
+	}
+	if filter.Limit > 0 {
+		db = db.Limit(filter.Limit)
+	}
+	if err := db.Order("id").Find(&logs).Error; err != nil {
+		return logs, err
+	}
+	return logs, nil
+}
+
+// CleanStatusLogs will delete old status logs, by the time the server received them
 func (logDB *LoggerDB) CleanStatusLogs(environment string, seconds int64) error {
 	minusSeconds := time.Now().Add(time.Duration(-seconds) * time.Second)
 	if err := logDB.Database.Conn.Unscoped().Where("environment = ?", environment).Where("created_at < ?", minusSeconds).Delete(&OsqueryStatusData{}).Error; err != nil {
@@ -221,7 +331,7 @@ func (logDB *LoggerDB) CleanStatusLogs(environment string, seconds int64) error
 	return nil
 }
 
-// CleanResultLogs will delete old status logs
+// CleanResultLogs will delete old result logs, by the time the server received them
 func (logDB *LoggerDB) CleanResultLogs(environment string, seconds int64) error {
 	minusSeconds := time.Now().Add(time.Duration(-seconds) * time.Second)
 	if err := logDB.Database.Conn.Unscoped().Where("environment = ?", environment).Where("created_at < ?", minusSeconds).Delete(&OsqueryResultData{}).Error; err != nil {
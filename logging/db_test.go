@@ -0,0 +1,47 @@
+package logging
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jmpsec/osctrl/types"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestResultDataEvent(t *testing.T) {
+	created := time.Date(2022, 3, 8, 14, 25, 0, 0, time.UTC)
+	r := OsqueryResultData{
+		Model:     gorm.Model{ID: 7, CreatedAt: created},
+		UUID:      "8D5B2A6E-4F5A-4C2B-9E0A-3B7C1D2E4F60",
+		Name:      "processes_snapshot",
+		Format:    types.ResultFormatSnapshot,
+		EventType: types.EventTypeSnapshot,
+		Action:    types.ResultActionSnapshot,
+		Columns:   `{"name":"sshd","pid":1042}`,
+		Numerics:  true,
+		UnixTime:  1646956800,
+	}
+	e, err := r.Event()
+	assert.NoError(t, err)
+	assert.Equal(t, uint(7), e.ID)
+	assert.Equal(t, created, e.Time)
+	assert.Equal(t, time.Unix(1646956800, 0).UTC(), e.AgentTime)
+	assert.Equal(t, map[string]string{"name": "sshd", "pid": "1042"}, e.Columns)
+	// Logs stored before events were typed
+	legacy := OsqueryResultData{
+		Model:   gorm.Model{CreatedAt: created},
+		Name:    "users",
+		Action:  types.ResultActionRemoved,
+		Columns: `{"uid":"1003"}`,
+	}
+	e, err = legacy.Event()
+	assert.NoError(t, err)
+	assert.Equal(t, types.ResultFormatEvent, e.Format)
+	assert.Equal(t, types.EventTypeDifferential, e.EventType)
+	assert.Equal(t, created, e.Time)
+	assert.True(t, e.AgentTime.IsZero())
+	legacy.Columns = "broken"
+	_, err = legacy.Event()
+	assert.Error(t, err)
+}
@@ -0,0 +1,511 @@
+package logging
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+	"gorm.io/gorm"
+)
+
+const (
+	// DefaultDeadLetterEntries is the default maximum number of dead letters kept, oldest are evicted first
+	DefaultDeadLetterEntries = 10000
+	// DefaultDeadLetterDays is the default days to keep dead letters
+	DefaultDeadLetterDays = 7
+	// DefaultReplayInterval is the default interval to look for dead letters to replay
+	DefaultReplayInterval = 30 * time.Second
+	// Dead letters replayed in every run
+	replayBatch = 100
+	// Dead letters claimed for longer than this were interrupted and are not replayed again
+	replayStale = 10 * time.Minute
+	// Attempts to deliver logs before they are dead letters
+	deliveryAttempts = 3
+	// Delay before the first retry of a delivery, doubled on every retry
+	deliveryBackoff = 200 * time.Millisecond
+)
+
+// States of dead letters
+const (
+	// DeadLetterFailed is a dead letter waiting to be inspected, replayed or purged
+	DeadLetterFailed = "failed"
+	// DeadLetterReplay is a dead letter waiting to be replayed by the TLS service
+	DeadLetterReplay = "replay"
+	// DeadLetterReplaying is a dead letter claimed by one TLS service to replay it
+	DeadLetterReplaying = "replaying"
+)
+
+// Reasons of failed deliveries
+const (
+	// ReasonRejected is used when the backend rejects the events, like schema errors
+	ReasonRejected = "rejected"
+	// ReasonTooLarge is used when the backend rejects the size of the payload
+	ReasonTooLarge = "payload-too-large"
+	// ReasonUnauthorized is used when the backend rejects the credentials
+	ReasonUnauthorized = "unauthorized"
+	// ReasonUnavailable is used when the backend is still failing after all the retries
+	ReasonUnavailable = "unavailable"
+	// ReasonNoTarget is used when a replay asks for a backend the TLS service does not have
+	ReasonNoTarget = "replay-target-unavailable"
+	// ReasonInterrupted is used when a replay stopped before confirming the delivery
+	ReasonInterrupted = "replay-interrupted"
+)
+
+// DeliveryError to classify the failed delivery of logs to a backend
+type DeliveryError struct {
+	// Permanent errors are not retried
+	Permanent bool
+	Reason    string
+	Err       error
+}
+
+func (e *DeliveryError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Reason, e.Err)
+}
+
+func (e *DeliveryError) Unwrap() error {
+	return e.Err
+}
+
+// HTTPDeliveryError to classify the response of a backend that receives logs over HTTP, nil if it was accepted
+func HTTPDeliveryError(code int, body []byte, err error) error {
+	if err != nil {
+		return &DeliveryError{Reason: ReasonUnavailable, Err: err}
+	}
+	if code >= 200 && code < 300 {
+		return nil
+	}
+	e := &DeliveryError{Permanent: true, Reason: ReasonRejected, Err: fmt.Errorf("HTTP %d %s", code, string(body))}
+	switch {
+	case code == http.StatusRequestEntityTooLarge:
+		e.Reason = ReasonTooLarge
+	case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		e.Reason = ReasonUnauthorized
+	case code == http.StatusTooManyRequests || code == http.StatusRequestTimeout || code >= 500:
+		e.Permanent = false
+		e.Reason = ReasonUnavailable
+	}
+	return e
+}
+
+// Deliver to send logs to a backend, retrying failures that are not permanent
+func Deliver(send func() error) error {
+	var err error
+	for i := 0; i < deliveryAttempts; i++ {
+		if i > 0 {
+			time.Sleep(deliveryBackoff << uint(i-1))
+		}
+		if err = send(); err == nil {
+			return nil
+		}
+		var derr *DeliveryError
+		if errors.As(err, &derr) && derr.Permanent {
+			return err
+		}
+	}
+	return err
+}
+
+// DeadLetter to keep logs that could not be delivered to a backend, so they can be replayed
+type DeadLetter struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt    time.Time `gorm:"index" json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	Backend      string    `gorm:"index" json:"backend"`
+	Reason       string    `gorm:"index" json:"reason"`
+	Error        string    `json:"error"`
+	LogType      string    `json:"log_type"`
+	Environment  string    `gorm:"index" json:"environment"`
+	UUID         string    `json:"uuid"`
+	Name         string    `json:"name"`
+	Status       int       `json:"status"`
+	Payload      []byte    `json:"payload"`
+	Size         int       `json:"size"`
+	Digest       string    `gorm:"index" json:"digest"`
+	Attempts     int       `json:"attempts"`
+	State        string    `gorm:"index" json:"state"`
+	ReplayTarget string    `json:"replay_target"`
+	ClaimedBy    string    `json:"-"`
+	FailedAt     time.Time `json:"failed_at"`
+}
+
+// DeadLetterCount to count dead letters by backend and reason
+type DeadLetterCount struct {
+	Backend string `json:"backend"`
+	Reason  string `json:"reason"`
+	State   string `json:"state"`
+	Count   int64  `json:"count"`
+	Size    int64  `json:"size"`
+}
+
+// DeadLetterFilter to select dead letters, zero values match everything
+type DeadLetterFilter struct {
+	IDs         []uint
+	Backend     string
+	Reason      string
+	Environment string
+	Before      time.Time
+	Limit       int
+}
+
+// DeadLetterReport to inspect dead letters, with the counts by reason and the latest entries
+type DeadLetterReport struct {
+	Counts  []DeadLetterCount `json:"counts"`
+	Entries []DeadLetter      `json:"entries"`
+}
+
+// Empty to check if the filter matches all dead letters
+func (f DeadLetterFilter) Empty() bool {
+	return len(f.IDs) == 0 && f.Backend == "" && f.Reason == "" && f.Environment == "" && f.Before.IsZero()
+}
+
+// DeadLetters to store logs that failed delivery, bounded by entries and age
+type DeadLetters struct {
+	DB         *gorm.DB
+	MaxEntries int
+	MaxAge     time.Duration
+	mux        sync.Mutex
+	evicted    int
+}
+
+// CreateDeadLetters to initialize the dead letters and its table
+func CreateDeadLetters(backend *gorm.DB) *DeadLetters {
+	// table dead_letters
+	if err := backend.AutoMigrate(&DeadLetter{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (dead_letters): %v", err)
+	}
+	return &DeadLetters{
+		DB:         backend,
+		MaxEntries: DefaultDeadLetterEntries,
+		MaxAge:     time.Duration(DefaultDeadLetterDays) * 24 * time.Hour,
+	}
+}
+
+// SetLimits to change the maximum entries and days of dead letters, 0 keeps the default
+func (d *DeadLetters) SetLimits(entries, days int) {
+	if entries <= 0 {
+		entries = DefaultDeadLetterEntries
+	}
+	if days <= 0 {
+		days = DefaultDeadLetterDays
+	}
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	d.MaxEntries = entries
+	d.MaxAge = time.Duration(days) * 24 * time.Hour
+}
+
+// Helper to apply the filter to a query of dead letters
+func (f DeadLetterFilter) apply(tx *gorm.DB) *gorm.DB {
+	if len(f.IDs) > 0 {
+		tx = tx.Where("id IN ?", f.IDs)
+	}
+	if f.Backend != "" {
+		tx = tx.Where("backend = ?", f.Backend)
+	}
+	if f.Reason != "" {
+		tx = tx.Where("reason = ?", f.Reason)
+	}
+	if f.Environment != "" {
+		tx = tx.Where("environment = ?", f.Environment)
+	}
+	if !f.Before.IsZero() {
+		tx = tx.Where("created_at < ?", f.Before)
+	}
+	return tx
+}
+
+// Add to store logs that failed delivery. The same payload failing again for the same backend updates the
+// existing dead letter, so it is only replayed once.
+func (d *DeadLetters) Add(letter DeadLetter) error {
+	sum := sha256.Sum256(letter.Payload)
+	letter.Digest = fmt.Sprintf("%x", sum)
+	letter.Size = len(letter.Payload)
+	letter.State = DeadLetterFailed
+	letter.Attempts = 1
+	if letter.FailedAt.IsZero() {
+		letter.FailedAt = time.Now()
+	}
+	res := d.DB.Model(&DeadLetter{}).Where("backend = ? AND digest = ? AND state = ?", letter.Backend, letter.Digest, DeadLetterFailed).Updates(map[string]interface{}{
+		"reason":    letter.Reason,
+		"error":     letter.Error,
+		"failed_at": letter.FailedAt,
+		"attempts":  gorm.Expr("attempts + 1"),
+	})
+	if res.Error != nil {
+		return fmt.Errorf("Updates %v", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		if err := d.DB.Create(&letter).Error; err != nil {
+			return fmt.Errorf("Create %v", err)
+		}
+	}
+	return d.evict(time.Now())
+}
+
+// Helper to delete dead letters older than the maximum age and the oldest ones over the maximum entries
+func (d *DeadLetters) evict(now time.Time) error {
+	d.mux.Lock()
+	maxEntries, maxAge := d.MaxEntries, d.MaxAge
+	d.mux.Unlock()
+	var evicted int64
+	if maxAge > 0 {
+		res := d.DB.Where("created_at < ? AND state <> ?", now.Add(-maxAge), DeadLetterReplaying).Delete(&DeadLetter{})
+		if res.Error != nil {
+			return fmt.Errorf("Delete age %v", res.Error)
+		}
+		evicted += res.RowsAffected
+	}
+	if maxEntries > 0 {
+		var total int64
+		if err := d.DB.Model(&DeadLetter{}).Count(&total).Error; err != nil {
+			return fmt.Errorf("Count %v", err)
+		}
+		if over := total - int64(maxEntries); over > 0 {
+			oldest := d.DB.Model(&DeadLetter{}).Select("id").Where("state <> ?", DeadLetterReplaying).Order("id").Limit(int(over))
+			res := d.DB.Where("id IN (?)", oldest).Delete(&DeadLetter{})
+			if res.Error != nil {
+				return fmt.Errorf("Delete entries %v", res.Error)
+			}
+			evicted += res.RowsAffected
+		}
+	}
+	if evicted > 0 {
+		d.mux.Lock()
+		d.evicted += int(evicted)
+		d.mux.Unlock()
+	}
+	return nil
+}
+
+// Evicted to get the dead letters evicted since the last call
+func (d *DeadLetters) Evicted() int {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	evicted := d.evicted
+	d.evicted = 0
+	return evicted
+}
+
+// Counts to get the number and size of dead letters by backend, reason and state
+func (d *DeadLetters) Counts() ([]DeadLetterCount, error) {
+	var counts []DeadLetterCount
+	err := d.DB.Model(&DeadLetter{}).Select("backend, reason, state, count(*) as count, sum(size) as size").Group("backend, reason, state").Order("backend, reason, state").Scan(&counts).Error
+	if err != nil {
+		return counts, fmt.Errorf("Counts %v", err)
+	}
+	return counts, nil
+}
+
+// List to get dead letters, newest first
+func (d *DeadLetters) List(filter DeadLetterFilter) ([]DeadLetter, error) {
+	var letters []DeadLetter
+	tx := filter.apply(d.DB.Model(&DeadLetter{})).Order("id DESC")
+	if filter.Limit > 0 {
+		tx = tx.Limit(filter.Limit)
+	}
+	if err := tx.Find(&letters).Error; err != nil {
+		return letters, fmt.Errorf("Find %v", err)
+	}
+	return letters, nil
+}
+
+// Report to get the counts of all dead letters and the latest entries matching the filter
+func (d *DeadLetters) Report(filter DeadLetterFilter) (DeadLetterReport, error) {
+	var report DeadLetterReport
+	var err error
+	if report.Counts, err = d.Counts(); err != nil {
+		return report, err
+	}
+	if report.Entries, err = d.List(filter); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// Replay to ask the TLS service to deliver again the failed dead letters, to their backend or to the target
+func (d *DeadLetters) Replay(filter DeadLetterFilter, target string) (int64, error) {
+	tx := filter.apply(d.DB.Model(&DeadLetter{})).Where("state = ?", DeadLetterFailed)
+	if filter.Limit > 0 {
+		ids := filter.apply(d.DB.Model(&DeadLetter{})).Select("id").Where("state = ?", DeadLetterFailed).Order("id").Limit(filter.Limit)
+		tx = d.DB.Model(&DeadLetter{}).Where("id IN (?)", ids)
+	}
+	res := tx.Updates(map[string]interface{}{"state": DeadLetterReplay, "replay_target": target})
+	if res.Error != nil {
+		return 0, fmt.Errorf("Replay %v", res.Error)
+	}
+	return res.RowsAffected, nil
+}
+
+// Purge to delete dead letters, oldest first with a limit, the ones being replayed are kept
+func (d *DeadLetters) Purge(filter DeadLetterFilter) (int64, error) {
+	tx := filter.apply(d.DB).Where("state <> ?", DeadLetterReplaying)
+	if filter.Limit > 0 {
+		ids := filter.apply(d.DB.Model(&DeadLetter{})).Select("id").Where("state <> ?", DeadLetterReplaying).Order("id").Limit(filter.Limit)
+		tx = d.DB.Where("id IN (?)", ids)
+	}
+	res := tx.Delete(&DeadLetter{})
+	if res.Error != nil {
+		return 0, fmt.Errorf("Purge %v", res.Error)
+	}
+	return res.RowsAffected, nil
+}
+
+// Claim to take dead letters waiting to be replayed. Claims are atomic, so each dead letter is replayed by
+// only one TLS service, and claims interrupted for too long go back to failed instead of being replayed twice.
+func (d *DeadLetters) Claim(holder string, limit int, now time.Time) ([]DeadLetter, error) {
+	var letters []DeadLetter
+	err := d.DB.Model(&DeadLetter{}).Where("state = ? AND updated_at < ?", DeadLetterReplaying, now.Add(-replayStale)).Updates(map[string]interface{}{
+		"state":      DeadLetterFailed,
+		"reason":     ReasonInterrupted,
+		"claimed_by": "",
+	}).Error
+	if err != nil {
+		return letters, fmt.Errorf("Updates stale %v", err)
+	}
+	ids := d.DB.Model(&DeadLetter{}).Select("id").Where("state = ?", DeadLetterReplay).Order("id").Limit(limit)
+	err = d.DB.Model(&DeadLetter{}).Where("id IN (?) AND state = ?", ids, DeadLetterReplay).Updates(map[string]interface{}{
+		"state":      DeadLetterReplaying,
+		"claimed_by": holder,
+	}).Error
+	if err != nil {
+		return letters, fmt.Errorf("Updates claim %v", err)
+	}
+	if err := d.DB.Where("state = ? AND claimed_by = ?", DeadLetterReplaying, holder).Order("id").Find(&letters).Error; err != nil {
+		return letters, fmt.Errorf("Find %v", err)
+	}
+	return letters, nil
+}
+
+// Replayed to delete a dead letter once it was delivered
+func (d *DeadLetters) Replayed(letter DeadLetter) error {
+	if err := d.DB.Where("id = ? AND claimed_by = ?", letter.ID, letter.ClaimedBy).Delete(&DeadLetter{}).Error; err != nil {
+		return fmt.Errorf("Delete %v", err)
+	}
+	return nil
+}
+
+// ReplayFailed to return a dead letter to failed after it could not be replayed
+func (d *DeadLetters) ReplayFailed(letter DeadLetter, reason string, replayErr error) error {
+	err := d.DB.Model(&DeadLetter{}).Where("id = ? AND claimed_by = ?", letter.ID, letter.ClaimedBy).Updates(map[string]interface{}{
+		"state":      DeadLetterFailed,
+		"reason":     reason,
+		"error":      fmt.Sprintf("%v", replayErr),
+		"attempts":   gorm.Expr("attempts + 1"),
+		"failed_at":  time.Now(),
+		"claimed_by": "",
+	}).Error
+	if err != nil {
+		return fmt.Errorf("Updates %v", err)
+	}
+	return nil
+}
+
+// Helper to store logs that failed delivery as a dead letter, errors are only logged
+func (logTLS *LoggerTLS) deadLetter(backend string, letter DeadLetter, err error) {
+	if err == nil {
+		return
+	}
+	log.Printf("error delivering %s logs to %s %v", letter.LogType, backend, err)
+	if logTLS.DeadLetters == nil {
+		return
+	}
+	letter.Backend = backend
+	letter.Reason = ReasonUnavailable
+	letter.Error = err.Error()
+	var derr *DeliveryError
+	if errors.As(err, &derr) {
+		letter.Reason = derr.Reason
+	}
+	if err := logTLS.DeadLetters.Add(letter); err != nil {
+		log.Printf("error storing dead letter %v", err)
+	}
+}
+
+// Helper to deliver a dead letter again, to the configured logger or to the always logger in the DB
+func (logTLS *LoggerTLS) replay(letter DeadLetter, debug bool) (string, error) {
+	target := letter.ReplayTarget
+	if target == "" {
+		target = letter.Backend
+	}
+	switch {
+	case target == logTLS.Logging:
+		if letter.LogType == types.QueryLog {
+			return ReasonUnavailable, logTLS.sendQuery(letter.LogType, letter.Payload, letter.Environment, letter.UUID, letter.Name, letter.Status, debug)
+		}
+		return ReasonUnavailable, logTLS.send(letter.LogType, letter.Payload, letter.Environment, letter.UUID, debug)
+	case target == settings.LoggingDB && logTLS.AlwaysLogger != nil:
+		if letter.LogType == types.QueryLog {
+			logTLS.AlwaysLogger.Query(letter.Payload, letter.Environment, letter.UUID, letter.Name, letter.Status, debug)
+		} else {
+			logTLS.AlwaysLogger.Log(letter.LogType, letter.Payload, letter.Environment, letter.UUID, debug)
+		}
+		return "", nil
+	}
+	return ReasonNoTarget, fmt.Errorf("backend %s is not available for replays", target)
+}
+
+// ReplayDeadLetters to deliver again the dead letters waiting to be replayed, returns how many were delivered
+func (logTLS *LoggerTLS) ReplayDeadLetters(holder string, debug bool) (int, error) {
+	letters, err := logTLS.DeadLetters.Claim(holder, replayBatch, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	delivered := 0
+	for _, letter := range letters {
+		reason, err := logTLS.replay(letter, debug)
+		if err != nil {
+			var derr *DeliveryError
+			if errors.As(err, &derr) {
+				reason = derr.Reason
+			}
+			if err := logTLS.DeadLetters.ReplayFailed(letter, reason, err); err != nil {
+				log.Printf("error returning dead letter %d %v", letter.ID, err)
+			}
+			continue
+		}
+		if err := logTLS.DeadLetters.Replayed(letter); err != nil {
+			log.Printf("error deleting replayed dead letter %d %v", letter.ID, err)
+		}
+		delivered++
+	}
+	return delivered, nil
+}
+
+// StartReplay to replay periodically the dead letters requested from osctrl-admin or osctrl-cli
+func (logTLS *LoggerTLS) StartReplay(ctx context.Context, interval time.Duration) {
+	if logTLS.DeadLetters == nil {
+		return
+	}
+	if interval <= 0 {
+		interval = DefaultReplayInterval
+	}
+	hostname, _ := os.Hostname()
+	holder := fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				delivered, err := logTLS.ReplayDeadLetters(holder, false)
+				if err != nil {
+					log.Printf("error replaying dead letters %v", err)
+				}
+				if delivered > 0 {
+					log.Printf("Replayed %d dead letters", delivered)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,73 @@
+package logging
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestHTTPDeliveryError(t *testing.T) {
+	cases := []struct {
+		code      int
+		err       error
+		reason    string
+		permanent bool
+	}{
+		{http.StatusBadRequest, nil, ReasonRejected, true},
+		{http.StatusRequestEntityTooLarge, nil, ReasonTooLarge, true},
+		{http.StatusForbidden, nil, ReasonUnauthorized, true},
+		{http.StatusTooManyRequests, nil, ReasonUnavailable, false},
+		{http.StatusServiceUnavailable, nil, ReasonUnavailable, false},
+		{0, errors.New("connection refused"), ReasonUnavailable, false},
+	}
+	for _, c := range cases {
+		var derr *DeliveryError
+		if !errors.As(HTTPDeliveryError(c.code, nil, c.err), &derr) {
+			t.Fatalf("code %d should be a delivery error", c.code)
+		}
+		if derr.Reason != c.reason || derr.Permanent != c.permanent {
+			t.Errorf("code %d got %s/%v, expected %s/%v", c.code, derr.Reason, derr.Permanent, c.reason, c.permanent)
+		}
+	}
+	if err := HTTPDeliveryError(http.StatusOK, nil, nil); err != nil {
+		t.Errorf("accepted logs should not fail: %v", err)
+	}
+}
+
+func TestDeliverRetries(t *testing.T) {
+	calls := 0
+	err := Deliver(func() error {
+		calls++
+		if calls < deliveryAttempts {
+			return &DeliveryError{Reason: ReasonUnavailable, Err: errors.New("timeout")}
+		}
+		return nil
+	})
+	if err != nil || calls != deliveryAttempts {
+		t.Errorf("transient failures should be retried, got %v after %d calls", err, calls)
+	}
+}
+
+func TestDeliverPermanent(t *testing.T) {
+	calls := 0
+	err := Deliver(func() error {
+		calls++
+		return &DeliveryError{Permanent: true, Reason: ReasonRejected, Err: errors.New("bad schema")}
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("permanent failures should not be retried, got %v after %d calls", err, calls)
+	}
+}
+
+func TestDeadLetterFilterEmpty(t *testing.T) {
+	if !(DeadLetterFilter{Limit: 10}).Empty() {
+		t.Errorf("a limit alone should not select dead letters")
+	}
+	if (DeadLetterFilter{Reason: ReasonRejected}).Empty() {
+		t.Errorf("a reason should select dead letters")
+	}
+	if (DeadLetterFilter{Before: time.Now()}).Empty() {
+		t.Errorf("an age should select dead letters")
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/spf13/viper"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/kinesis"
@@ -77,21 +78,37 @@ func (logSK *LoggerKinesis) Settings(mgr *settings.Settings) {
 	log.Printf("No kinesis logging settings\n")
 }
 
-// Send - Function that sends JSON logs to Splunk HTTP Event Collector
-func (logSK *LoggerKinesis) Send(logType string, data []byte, environment, uuid string, debug bool) {
+// Send - Function that sends JSON logs to Kinesis, retrying failures that are not permanent
+func (logSK *LoggerKinesis) Send(logType string, data []byte, environment, uuid string, debug bool) error {
 	if debug {
 		log.Printf("DebugService: Sending %d bytes to Kinesis for %s - %s", len(data), environment, uuid)
 	}
 	streamName := aws.String(logSK.Configuration.Stream)
-	putOutput, err := logSK.KinesisClient.PutRecord(&kinesis.PutRecordInput{
-		Data:         []byte(data),
-		StreamName:   streamName,
-		PartitionKey: aws.String(logType + ":" + environment + ":" + uuid),
+	return Deliver(func() error {
+		putOutput, err := logSK.KinesisClient.PutRecord(&kinesis.PutRecordInput{
+			Data:         []byte(data),
+			StreamName:   streamName,
+			PartitionKey: aws.String(logType + ":" + environment + ":" + uuid),
+		})
+		if err != nil {
+			return kinesisDeliveryError(err)
+		}
+		if debug {
+			log.Printf("DebugService: PutRecordOutput %s", putOutput.String())
+		}
+		return nil
 	})
-	if err != nil {
-		log.Printf("Error sending kinesis stream %s", err)
-	}
-	if debug {
-		log.Printf("DebugService: PutRecordOutput %s", putOutput.String())
+}
+
+// Helper to classify the errors of Kinesis, records that are not valid are never accepted
+func kinesisDeliveryError(err error) error {
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case kinesis.ErrCodeInvalidArgumentException, "ValidationException":
+			return &DeliveryError{Permanent: true, Reason: ReasonRejected, Err: err}
+		case "AccessDeniedException", "UnrecognizedClientException":
+			return &DeliveryError{Permanent: true, Reason: ReasonUnauthorized, Err: err}
+		}
 	}
+	return &DeliveryError{Reason: ReasonUnavailable, Err: err}
 }
@@ -1,10 +1,13 @@
 package logging
 
 import (
+	"encoding/json"
 	"log"
+	"sync"
 
 	"github.com/jmpsec/osctrl/backend"
 	"github.com/jmpsec/osctrl/cache"
+	"github.com/jmpsec/osctrl/environments"
 	"github.com/jmpsec/osctrl/nodes"
 	"github.com/jmpsec/osctrl/queries"
 	"github.com/jmpsec/osctrl/settings"
@@ -18,12 +21,29 @@ const (
 
 // LoggerTLS will be used to handle logging for the TLS endpoint
 type LoggerTLS struct {
-	Logging      string
-	Logger       interface{}
-	AlwaysLogger *LoggerDB
-	RedisCache   *cache.RedisManager
-	Nodes        *nodes.NodeManager
-	Queries      *queries.Queries
+	Logging         string
+	Logger          interface{}
+	AlwaysLogger    *LoggerDB
+	AlwaysTraffic   *AlwaysTraffic
+	RedisCache      *cache.RedisManager
+	Nodes           *nodes.NodeManager
+	Queries         *queries.Queries
+	Queue           *LogQueue
+	DeadLetters     *DeadLetters
+	alwaysFilters   AlwaysFilters
+	alwaysPaused    []string
+	alwaysMutex     sync.RWMutex
+	repeated        int
+	replaced        int
+	repeatsMutex    sync.Mutex
+	statusMax       int
+	statusTruncated int
+	statusSkipped   int
+	statusInvalid   int
+	statusMutex     sync.Mutex
+	Pseudonyms      *environments.PseudonymStore
+	pseudonymizers  map[string]*environments.Pseudonymizer
+	pseudonymsMutex sync.RWMutex
 }
 
 // CreateLoggerTLS to instantiate a new logger for the TLS endpoint
@@ -116,12 +136,17 @@ func CreateLoggerTLS(logging, loggingFile string, s3Conf types.S3Configuration,
 		}
 		always.Settings(mgr)
 		l.AlwaysLogger = always
+		traffic, err := CreateAlwaysTraffic(always.Database.Conn)
+		if err != nil {
+			return nil, err
+		}
+		l.AlwaysTraffic = traffic
 	}
 	return l, nil
 }
 
-// Log will send status/result logs via the configured method of logging
-func (logTLS *LoggerTLS) Log(logType string, data []byte, environment, uuid string, debug bool) {
+// Helper to send status/result logs via the configured method of logging, returns the error of failed deliveries
+func (logTLS *LoggerTLS) send(logType string, data []byte, environment, uuid string, debug bool) error {
 	switch logTLS.Logging {
 	case settings.LoggingSplunk:
 		l, ok := logTLS.Logger.(*LoggerSplunk)
@@ -129,7 +154,7 @@ func (logTLS *LoggerTLS) Log(logType string, data []byte, environment, uuid stri
 			log.Printf("error casting logger to %s", settings.LoggingSplunk)
 		}
 		if l.Enabled {
-			l.Send(logType, data, environment, uuid, debug)
+			return l.Send(logType, data, environment, uuid, debug)
 		}
 	case settings.LoggingGraylog:
 		l, ok := logTLS.Logger.(*LoggerGraylog)
@@ -177,7 +202,7 @@ func (logTLS *LoggerTLS) Log(logType string, data []byte, environment, uuid stri
 			log.Printf("error casting logger to %s", settings.LoggingKinesis)
 		}
 		if l.Enabled {
-			l.Send(logType, data, environment, uuid, debug)
+			return l.Send(logType, data, environment, uuid, debug)
 		}
 	case settings.LoggingS3:
 		l, ok := logTLS.Logger.(*LoggerS3)
@@ -188,28 +213,28 @@ func (logTLS *LoggerTLS) Log(logType string, data []byte, environment, uuid stri
 			l.Send(logType, data, environment, uuid, debug)
 		}
 	}
-	// If logs are status, write via always logger
-	if logTLS.AlwaysLogger != nil && logTLS.AlwaysLogger.Enabled && logType == types.StatusLog {
-		// Check if configured logger is DB so we skip logging the same data twice
-		logAlways := true
-		if logTLS.Logger == settings.LoggingDB {
-			l, ok := logTLS.Logger.(*LoggerDB)
-			if ok {
-				logAlways = !sameConfigDB(*l.Database.Config, *logTLS.AlwaysLogger.Database.Config)
-			}
-		}
-		if logAlways {
-			logTLS.AlwaysLogger.Log(logType, data, environment, uuid, debug)
-		}
+	return nil
+}
+
+// Log will send status/result logs via the configured method of logging
+func (logTLS *LoggerTLS) Log(logType string, data []byte, environment, uuid string, debug bool) {
+	sent := logTLS.pseudonymize(environment, data)
+	logTLS.deadLetter(logTLS.Logging, DeadLetter{LogType: logType, Payload: sent, Environment: environment, UUID: uuid}, logTLS.send(logType, sent, environment, uuid, debug))
+	// Write via always logger the logs that match the filters
+	if logTLS.alwaysEnabled() {
+		logTLS.alwaysLog(logType, data, environment, uuid, debug)
 	}
 	// Add logs to cache
+	if logTLS.RedisCache == nil {
+		return
+	}
 	if err := logTLS.RedisCache.SetLogs(logType, uuid, environment, data); err != nil {
 		log.Printf("error sending %s logs to cache %s", logType, err)
 	}
 }
 
-// QueryLog will send query result logs via the configured method of logging
-func (logTLS *LoggerTLS) QueryLog(logType string, data []byte, environment, uuid, name string, status int, debug bool) {
+// Helper to send query result logs via the configured method of logging, returns the error of failed deliveries
+func (logTLS *LoggerTLS) sendQuery(logType string, data []byte, environment, uuid, name string, status int, debug bool) error {
 	switch logTLS.Logging {
 	case settings.LoggingSplunk:
 		l, ok := logTLS.Logger.(*LoggerSplunk)
@@ -217,7 +242,7 @@ func (logTLS *LoggerTLS) QueryLog(logType string, data []byte, environment, uuid
 			log.Printf("error casting logger to %s", settings.LoggingSplunk)
 		}
 		if l.Enabled {
-			l.Send(logType, data, environment, uuid, debug)
+			return l.Send(logType, data, environment, uuid, debug)
 		}
 	case settings.LoggingGraylog:
 		l, ok := logTLS.Logger.(*LoggerGraylog)
@@ -265,7 +290,7 @@ func (logTLS *LoggerTLS) QueryLog(logType string, data []byte, environment, uuid
 			log.Printf("error casting logger to %s", settings.LoggingKinesis)
 		}
 		if l.Enabled {
-			l.Send(logType, data, environment, uuid, debug)
+			return l.Send(logType, data, environment, uuid, debug)
 		}
 	case settings.LoggingS3:
 		l, ok := logTLS.Logger.(*LoggerS3)
@@ -273,26 +298,166 @@ func (logTLS *LoggerTLS) QueryLog(logType string, data []byte, environment, uuid
 			log.Printf("error casting logger to %s", settings.LoggingS3)
 		}
 		if l.Enabled {
-			l.Send(logType, data, environment, uuid, debug)
+			l.SendName(logType, data, environment, uuid, name, debug)
 		}
 	}
-	// Always log results to DB if always logger is enabled
-	if logTLS.AlwaysLogger != nil && logTLS.AlwaysLogger.Enabled {
-		// Check if configured logger is DB so we skip logging the same data twice
-		logAlways := true
-		if logTLS.Logger == settings.LoggingDB {
-			l, ok := logTLS.Logger.(*LoggerDB)
-			if ok {
-				logAlways = !sameConfigDB(*l.Database.Config, *logTLS.AlwaysLogger.Database.Config)
-			}
-		}
-		if logAlways {
+	return nil
+}
+
+// QueryLog will send query result logs via the configured method of logging
+func (logTLS *LoggerTLS) QueryLog(logType string, data []byte, environment, uuid, name string, status int, debug bool) {
+	sent := logTLS.pseudonymize(environment, data)
+	logTLS.deadLetter(logTLS.Logging, DeadLetter{LogType: logType, Payload: sent, Environment: environment, UUID: uuid, Name: name, Status: status}, logTLS.sendQuery(logType, sent, environment, uuid, name, status, debug))
+	// Always log results to DB if always logger is enabled and the filters match
+	if logTLS.alwaysEnabled() {
+		logTLS.AlwaysTraffic.Add(logType, environment, name, 1)
+		if logTLS.GetAlwaysFilters().Match(logType, environment, name) {
 			logTLS.AlwaysLogger.Query(data, environment, uuid, name, status, debug)
 		}
 	}
 
 	// Add logs to cache always
+	if logTLS.RedisCache == nil {
+		return
+	}
 	if err := logTLS.RedisCache.SetQueryLogs(uuid, name, data); err != nil {
 		log.Printf("error sending %s logs to cache %s", logType, err)
 	}
 }
+
+// S3Stats to get the objects and bytes written by the S3 logger since the last call, false with other loggers
+func (logTLS *LoggerTLS) S3Stats() (S3Stats, bool) {
+	l, ok := logTLS.Logger.(*LoggerS3)
+	if !ok {
+		return S3Stats{}, false
+	}
+	return l.Stats(), true
+}
+
+// CloseLogger to write the logs buffered by the configured method of logging, before stopping the service
+func (logTLS *LoggerTLS) CloseLogger() {
+	if l, ok := logTLS.Logger.(*LoggerS3); ok {
+		l.Close()
+	}
+}
+
+// SetAlwaysFilters to replace the filters used by the always logger
+func (logTLS *LoggerTLS) SetAlwaysFilters(filters AlwaysFilters) {
+	logTLS.alwaysMutex.Lock()
+	defer logTLS.alwaysMutex.Unlock()
+	logTLS.alwaysFilters = filters
+}
+
+// SetAlwaysPaused to replace the environments where the always logger is paused by their storage quota
+func (logTLS *LoggerTLS) SetAlwaysPaused(environments []string) {
+	logTLS.alwaysMutex.Lock()
+	defer logTLS.alwaysMutex.Unlock()
+	logTLS.alwaysPaused = environments
+}
+
+// GetAlwaysFilters to retrieve the filters used by the always logger, environments paused by their storage
+// quota are disabled too
+func (logTLS *LoggerTLS) GetAlwaysFilters() AlwaysFilters {
+	logTLS.alwaysMutex.RLock()
+	defer logTLS.alwaysMutex.RUnlock()
+	filters := logTLS.alwaysFilters
+	if len(logTLS.alwaysPaused) > 0 {
+		disabled := make([]string, 0, len(filters.DisabledEnvs)+len(logTLS.alwaysPaused))
+		disabled = append(disabled, filters.DisabledEnvs...)
+		filters.DisabledEnvs = append(disabled, logTLS.alwaysPaused...)
+	}
+	return filters
+}
+
+// SetStorageCounters to count the rows and bytes written by the DB loggers for each environment
+func (logTLS *LoggerTLS) SetStorageCounters(counters *environments.StorageCounters) {
+	if l, ok := logTLS.Logger.(*LoggerDB); ok {
+		l.Counters = counters
+	}
+	if logTLS.AlwaysLogger != nil {
+		logTLS.AlwaysLogger.Counters = counters
+	}
+}
+
+// SetPseudonymizers to replace the pseudonymization of the environments, the logs of environments without it
+// are sent as they are
+func (logTLS *LoggerTLS) SetPseudonymizers(envsmap environments.MapEnvironments) {
+	pseudonymizers := make(map[string]*environments.Pseudonymizer)
+	for key, env := range envsmap {
+		// Environments are in the map by name and by UUID
+		if key != env.Name {
+			continue
+		}
+		if p := environments.NewPseudonymizer(env); p != nil {
+			if logTLS.Pseudonyms != nil {
+				p.Record = logTLS.Pseudonyms.Record
+			}
+			pseudonymizers[env.Name] = p
+		}
+	}
+	logTLS.pseudonymsMutex.Lock()
+	defer logTLS.pseudonymsMutex.Unlock()
+	logTLS.pseudonymizers = pseudonymizers
+}
+
+// Helper to pseudonymize the logs of an environment before they are sent to the configured method of logging.
+// The logs are not sent when they can not be pseudonymized, so configured columns never leak.
+func (logTLS *LoggerTLS) pseudonymize(environment string, data []byte) []byte {
+	logTLS.pseudonymsMutex.RLock()
+	p := logTLS.pseudonymizers[environment]
+	logTLS.pseudonymsMutex.RUnlock()
+	if p == nil {
+		return data
+	}
+	res, err := p.Transform(data)
+	if err != nil {
+		log.Printf("error pseudonymizing logs for %s %v", environment, err)
+		return []byte("[]")
+	}
+	return res
+}
+
+// Helper to check if the always logger is enabled, skipping it when the configured logger is the same DB
+func (logTLS *LoggerTLS) alwaysEnabled() bool {
+	if logTLS.AlwaysLogger == nil || !logTLS.AlwaysLogger.Enabled {
+		return false
+	}
+	if logTLS.Logging == settings.LoggingDB {
+		l, ok := logTLS.Logger.(*LoggerDB)
+		if ok {
+			return !sameConfigDB(*l.Database.Config, *logTLS.AlwaysLogger.Database.Config)
+		}
+	}
+	return true
+}
+
+// Helper to write status/result logs via always logger, only the entries that match the filters
+func (logTLS *LoggerTLS) alwaysLog(logType string, data []byte, environment, uuid string, debug bool) {
+	filters := logTLS.GetAlwaysFilters()
+	switch logType {
+	case types.StatusLog:
+		var entries []json.RawMessage
+		if err := json.Unmarshal(data, &entries); err != nil {
+			log.Printf("error parsing logs %s %v", string(data), err)
+		}
+		logTLS.AlwaysTraffic.Add(logType, environment, "", int64(len(entries)))
+		if filters.Match(logType, environment, "") {
+			logTLS.AlwaysLogger.Status(data, environment, uuid, debug)
+		}
+	case types.ResultLog:
+		logs, err := types.ParseResultLogs(data)
+		if err != nil {
+			log.Printf("error parsing logs %s %v", string(data), err)
+		}
+		var matched []types.LogResultData
+		for _, l := range logs {
+			logTLS.AlwaysTraffic.Add(logType, environment, l.Name, 1)
+			if filters.Match(logType, environment, l.Name) {
+				matched = append(matched, l)
+			}
+		}
+		if len(matched) > 0 {
+			logTLS.AlwaysLogger.ResultEntries(matched, environment)
+		}
+	}
+}
@@ -3,13 +3,25 @@ package logging
 import (
 	"encoding/json"
 	"log"
+	"time"
 
 	"github.com/jmpsec/osctrl/nodes"
+	"github.com/jmpsec/osctrl/queries"
 	"github.com/jmpsec/osctrl/types"
 )
 
-// ProcessLogs - Helper to process logs
-func (l *LoggerTLS) ProcessLogs(data json.RawMessage, logType, environment, ipaddress string, dataLen int, debug bool) {
+// ProcessLogs - Helper to process logs, received is the time of the server when the logs arrived
+func (l *LoggerTLS) ProcessLogs(data json.RawMessage, logType, environment, ipaddress string, dataLen int, received time.Time, debug bool) {
+	// Status logs are parsed one by one, so entries that are not valid or too big do not lose the batch
+	var hostIdentifier string
+	if logType == types.StatusLog {
+		sanitized, summary, err := l.sanitizeStatus(data, environment)
+		if err != nil {
+			log.Printf("error sanitizing status logs in %s %v", environment, err)
+			return
+		}
+		data, hostIdentifier = sanitized, summary.HostIdentifier
+	}
 	// Parse log to extract metadata
 	var logs []types.LogGenericData
 	if err := json.Unmarshal(data, &logs); err != nil {
@@ -31,6 +43,16 @@ func (l *LoggerTLS) ProcessLogs(data json.RawMessage, logType, environment, ipad
 		dhashes = append(dhashes, l.Decorations.DaemonHash)
 		osqueryversions = append(osqueryversions, l.Version)
 	}
+	if len(logs) == 0 {
+		// All the status logs were skipped, the node is still refreshed so it does not look silent
+		if hostIdentifier != "" {
+			if err := l.Nodes.RefreshLastStatus(hostIdentifier); err != nil {
+				log.Printf("error refreshing last status %v", err)
+			}
+		}
+		log.Printf("no logs to dispatch in %s:%s", logType, environment)
+		return
+	}
 	if debug {
 		log.Printf("metadata and dispatch for %s", uniq(uuids)[0])
 	}
@@ -45,11 +67,24 @@ func (l *LoggerTLS) ProcessLogs(data json.RawMessage, logType, environment, ipad
 		DaemonHash:     uniq(dhashes)[0],
 		OsqueryVersion: uniq(osqueryversions)[0],
 		BytesReceived:  dataLen,
+		AgentTime:      newestAgentTime(logs),
+		Received:       received,
 	}
 	// Dispatch logs and update metadata
 	l.DispatchLogs(data, uniq(uuids)[0], logType, environment, metadata, debug)
 }
 
+// Helper to get the newest time reported by the node in a batch of logs, zero if there is none
+func newestAgentTime(logs []types.LogGenericData) time.Time {
+	var newest time.Time
+	for _, l := range logs {
+		if t := l.Time(); t.After(newest) {
+			newest = t
+		}
+	}
+	return newest
+}
+
 // ProcessLogQueryResult - Helper to process on-demand query result logs
 func (l *LoggerTLS) ProcessLogQueryResult(queriesWrite types.QueryWriteRequest, envid uint, debug bool) {
 	// Retrieve node
@@ -63,27 +98,38 @@ func (l *LoggerTLS) ProcessLogQueryResult(queriesWrite types.QueryWriteRequest,
 	}
 	// Tap into results so we can update internal metrics
 	for q, r := range queriesWrite.Queries {
-		// Dispatch query name, result and status
-		d := types.QueryWriteData{
-			Name:    q,
-			Result:  r,
-			Status:  queriesWrite.Statuses[q],
-			Message: queriesWrite.Messages[q],
-		}
-		go l.DispatchQueries(d, node, debug)
-		// Update internal metrics per query
-		var err error
-		if queriesWrite.Statuses[q] != 0 {
-			err = l.Queries.IncError(q, envid)
-		} else {
-			err = l.Queries.IncExecution(q, envid)
+		profile, err := l.Queries.IsProfile(q, envid)
+		if err != nil {
+			log.Printf("error retrieving query %s", err)
 		}
+		// Each node counts once per query, results sent again by osquery are ignored
+		hash := queries.ResultHash(r, queriesWrite.Statuses[q], queriesWrite.Messages[q])
+		outcome, err := l.Queries.RecordExecution(q, node.UUID, envid, queriesWrite.Statuses[q], hash)
 		if err != nil {
-			log.Printf("error updating query %s", err)
+			log.Printf("error recording query execution %s", err)
+			continue
+		}
+		l.countExecution(outcome)
+		if outcome == queries.ExecutionRepeat {
+			if debug {
+				log.Printf("ignoring repeated result of %s from %s", q, node.UUID)
+			}
+			continue
 		}
-		// Add a record for this query
-		if err := l.Queries.TrackExecution(q, node.UUID, queriesWrite.Statuses[q]); err != nil {
-			log.Printf("error adding query execution %s", err)
+		if profile {
+			// Profile queries only keep execution metadata
+			if err := l.Queries.SaveProfileResult(q, node.UUID, r, queriesWrite.Statuses[q], profileStats(queriesWrite.Stats[q])); err != nil {
+				log.Printf("error saving profile result %s", err)
+			}
+		} else {
+			// Dispatch query name, result and status
+			d := types.QueryWriteData{
+				Name:    q,
+				Result:  r,
+				Status:  queriesWrite.Statuses[q],
+				Message: queriesWrite.Messages[q],
+			}
+			l.DispatchQueries(d, node, debug)
 		}
 		// Check if query is completed
 		if err := l.Queries.VerifyComplete(q, envid); err != nil {
@@ -91,3 +137,39 @@ func (l *LoggerTLS) ProcessLogQueryResult(queriesWrite types.QueryWriteRequest,
 		}
 	}
 }
+
+// Helper to count the results that were sent again or replaced a previous one
+func (l *LoggerTLS) countExecution(outcome string) {
+	l.repeatsMutex.Lock()
+	defer l.repeatsMutex.Unlock()
+	switch outcome {
+	case queries.ExecutionRepeat:
+		l.repeated++
+	case queries.ExecutionReplace:
+		l.replaced++
+	}
+}
+
+// Repeats to get the on-demand query results sent again and the ones that replaced a previous result, since the last call
+func (l *LoggerTLS) Repeats() (int, int) {
+	l.repeatsMutex.Lock()
+	defer l.repeatsMutex.Unlock()
+	repeated, replaced := l.repeated, l.replaced
+	l.repeated, l.replaced = 0, 0
+	return repeated, replaced
+}
+
+// Helper to convert the stats reported by osquery into profile stats
+func profileStats(stat types.QueryWriteStat) queries.ProfileStats {
+	wallTime := stat.WallTimeMs
+	if wallTime == 0 {
+		// Some osquery versions only report wall time in seconds
+		wallTime = stat.WallTime * 1000
+	}
+	return queries.ProfileStats{
+		WallTimeMs: wallTime,
+		UserTime:   stat.UserTime,
+		SystemTime: stat.SystemTime,
+		Memory:     stat.Memory,
+	}
+}
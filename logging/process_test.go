@@ -0,0 +1,57 @@
+package logging
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper to parse a fixture of logs like ProcessLogs does
+func fixtureLogs(t *testing.T, file string) []types.LogGenericData {
+	data, err := os.ReadFile("testdata/" + file)
+	assert.NoError(t, err)
+	var logs []types.LogGenericData
+	assert.NoError(t, json.Unmarshal(data, &logs))
+	return logs
+}
+
+func TestNewestAgentTime(t *testing.T) {
+	received := time.Date(2022, 3, 8, 14, 25, 0, 0, time.UTC)
+	// Clock of the node far in the future
+	future := newestAgentTime(fixtureLogs(t, "status-future.json"))
+	assert.Equal(t, time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC), future)
+	skew := nodes.ClockSkew(future, received)
+	assert.True(t, skew > 0)
+	assert.True(t, nodes.ClockSkewed(nodes.OsqueryNode{AgentTime: future, ClockSkew: skew}, nodes.DefaultClockSkew))
+	// Clock of the node reset to the epoch, calendarTime is used when unixTime is missing
+	ancient := newestAgentTime(fixtureLogs(t, "status-ancient.json"))
+	assert.Equal(t, time.Unix(42, 0).UTC(), ancient)
+	skew = nodes.ClockSkew(ancient, received)
+	assert.True(t, skew < 0)
+	assert.Equal(t, "1m0s behind", nodes.FormatSkew(-60))
+	assert.True(t, newestAgentTime(nil).IsZero())
+}
+
+func TestResultDataEventSkewed(t *testing.T) {
+	// Events are ordered and retained by the time of the server, whatever the node reports
+	created := time.Date(2022, 3, 8, 14, 25, 0, 0, time.UTC)
+	for _, unixTime := range []int64{2524608000, 42} {
+		r := OsqueryResultData{
+			UUID:     "3F1E9C2A-7B4D-4E8F-A0C1-5D6E7F8A9B0C",
+			Name:     "crontab",
+			Action:   types.ResultActionAdded,
+			Columns:  `{"command":"/tmp/.x"}`,
+			UnixTime: unixTime,
+		}
+		r.CreatedAt = created
+		e, err := r.Event()
+		assert.NoError(t, err)
+		assert.Equal(t, created, e.Time)
+		assert.Equal(t, time.Unix(unixTime, 0).UTC(), e.AgentTime)
+	}
+}
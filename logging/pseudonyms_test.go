@@ -0,0 +1,101 @@
+package logging
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Values of configured columns that must never reach a logger
+var pseudonymLeaks = []string{"alice", "laptop-alice", "bob"}
+
+const pseudonymResultLog = `[{"name":"users","hostIdentifier":"host1","columns":{"username":"alice","uid":"501"},"decorations":{"hostname":"laptop-alice"}},` +
+	`{"name":"pack_users_logged","snapshot":[{"user":"bob","tty":"ttys000"}]}]`
+
+const pseudonymQueryLog = `{"name":"pack_users_now","result":[{"user":"bob"},{"username":"alice"}],"status":0,"message":""}`
+
+func pseudonymEnvs() environments.MapEnvironments {
+	prod := environments.TLSEnvironment{
+		Name:             "prod",
+		UUID:             "prod-uuid",
+		PseudonymColumns: "username,hostname",
+		PseudonymTables:  "pack_users_*",
+		PseudonymKey:     "000102030405060708090a0b0c0d0e0f000102030405060708090a0b0c0d0e0f",
+	}
+	return environments.MapEnvironments{prod.Name: prod, prod.UUID: prod, "dev": {Name: "dev"}}
+}
+
+func assertNoLeaks(t *testing.T, payload string) {
+	for _, leaked := range pseudonymLeaks {
+		assert.NotContains(t, payload, leaked)
+	}
+	assert.Contains(t, payload, environments.PseudonymPrefix)
+}
+
+func TestPseudonymsSplunk(t *testing.T) {
+	var mutex sync.Mutex
+	var payloads []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mutex.Lock()
+		payloads = append(payloads, string(body))
+		mutex.Unlock()
+	}))
+	defer server.Close()
+	l := &LoggerTLS{
+		Logging: settings.LoggingSplunk,
+		Logger:  &LoggerSplunk{Configuration: SlunkConfiguration{URL: server.URL}, Headers: map[string]string{}, Enabled: true},
+	}
+	l.SetPseudonymizers(pseudonymEnvs())
+	l.Log(types.ResultLog, []byte(pseudonymResultLog), "prod", "uuid1", false)
+	l.QueryLog(types.QueryLog, []byte(pseudonymQueryLog), "prod", "uuid1", "pack_users_now", 0, false)
+	l.Log(types.ResultLog, []byte(pseudonymResultLog), "dev", "uuid2", false)
+	require.Len(t, payloads, 3)
+	assertNoLeaks(t, payloads[0])
+	assertNoLeaks(t, payloads[1])
+	// Environments without pseudonyms are sent as they are
+	assert.Contains(t, payloads[2], "laptop-alice")
+}
+
+func TestPseudonymsS3(t *testing.T) {
+	tmpl, err := ParseS3KeyTemplate("")
+	require.NoError(t, err)
+	s3 := &LoggerS3{Template: tmpl, Batcher: NewS3Batcher(tmpl, 10, 3600), Enabled: true}
+	l := &LoggerTLS{Logging: settings.LoggingS3, Logger: s3}
+	l.SetPseudonymizers(pseudonymEnvs())
+	l.Log(types.ResultLog, []byte(pseudonymResultLog), "prod", "uuid1", false)
+	l.QueryLog(types.QueryLog, []byte(pseudonymQueryLog), "prod", "uuid1", "pack_users_now", 0, false)
+	l.Log(types.ResultLog, []byte(pseudonymResultLog), "dev", "uuid2", false)
+	batches := s3.Batcher.Drain()
+	require.Len(t, batches, 3)
+	for _, b := range batches {
+		if b.Vars.Environment == "dev" {
+			assert.Contains(t, string(b.Data), "laptop-alice")
+			continue
+		}
+		assertNoLeaks(t, string(b.Data))
+	}
+}
+
+func TestPseudonymsRecorded(t *testing.T) {
+	l := &LoggerTLS{}
+	l.SetPseudonymizers(pseudonymEnvs())
+	var recorded []string
+	l.pseudonymizers["prod"].Record = func(envID uint, column, value, pseudonym string) {
+		recorded = append(recorded, value)
+	}
+	out := l.pseudonymize("prod", []byte(pseudonymResultLog))
+	assert.NotContains(t, string(out), "alice")
+	assert.ElementsMatch(t, []string{"alice", "laptop-alice", "bob", "ttys000"}, recorded)
+	// Logs that can not be pseudonymized are not sent
+	assert.Equal(t, "[]", string(l.pseudonymize("prod", []byte(`[{"username":`))))
+	assert.Equal(t, `[{"username":`, string(l.pseudonymize("dev", []byte(`[{"username":`))))
+}
@@ -0,0 +1,288 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jmpsec/osctrl/types"
+)
+
+const (
+	// DefaultQueueWorkers is the default number of workers draining the log queue
+	DefaultQueueWorkers = 8
+	// DefaultQueueSize is the default maximum number of pending batches in the log queue
+	DefaultQueueSize = 10000
+	// DefaultQueueShutdown is the default time to drain the log queue on shutdown
+	DefaultQueueShutdown = 30 * time.Second
+)
+
+// Kinds of jobs that can be queued
+const (
+	jobLogs = iota
+	jobQueryResult
+)
+
+// ErrQueueFull is returned when a batch is rejected because the queue is full
+var ErrQueueFull = fmt.Errorf("log queue is full")
+
+// ErrQueueClosed is returned when a batch is rejected because the queue is shutting down
+var ErrQueueClosed = fmt.Errorf("log queue is closed")
+
+// QueueConfig to hold all the configuration values for the log queue
+type QueueConfig struct {
+	// Number of workers draining the queue
+	Workers int
+	// Maximum number of pending batches across all environments
+	Size int
+	// Maximum number of pending batches for a single environment, 0 means Size
+	EnvSize int
+	// If true, enqueue blocks when the queue is full instead of dropping the batch
+	Block bool
+}
+
+// QueueStats to expose the state of the log queue as metrics
+type QueueStats struct {
+	Depth     int
+	Rejected  int
+	Processed map[string]int
+	Pending   map[string]int
+}
+
+// logJob to hold one parsed batch of logs to be dispatched
+type logJob struct {
+	kind        int
+	environment string
+	data        json.RawMessage
+	logType     string
+	ipaddress   string
+	dataLen     int
+	queryWrite  types.QueryWriteRequest
+	envid       uint
+	received    time.Time
+	debug       bool
+}
+
+// LogQueue is a bounded in-memory queue of log batches, drained by a pool of
+// workers that take batches from each environment in round-robin order
+type LogQueue struct {
+	Config    QueueConfig
+	mux       sync.Mutex
+	notEmpty  *sync.Cond
+	notFull   *sync.Cond
+	pending   map[string][]logJob
+	order     []string
+	next      int
+	depth     int
+	rejected  int
+	processed map[string]int
+	closed    bool
+	workers   sync.WaitGroup
+	process   func(logJob)
+}
+
+// CreateLogQueue to initialize the log queue, workers are started with Start
+func CreateLogQueue(cfg QueueConfig, process func(logJob)) *LogQueue {
+	if cfg.Workers <= 0 {
+		cfg.Workers = DefaultQueueWorkers
+	}
+	if cfg.Size <= 0 {
+		cfg.Size = DefaultQueueSize
+	}
+	if cfg.EnvSize <= 0 || cfg.EnvSize > cfg.Size {
+		cfg.EnvSize = cfg.Size
+	}
+	q := &LogQueue{
+		Config:    cfg,
+		pending:   make(map[string][]logJob),
+		processed: make(map[string]int),
+		process:   process,
+	}
+	q.notEmpty = sync.NewCond(&q.mux)
+	q.notFull = sync.NewCond(&q.mux)
+	return q
+}
+
+// Start to launch the workers that drain the queue
+func (q *LogQueue) Start() {
+	for i := 0; i < q.Config.Workers; i++ {
+		q.workers.Add(1)
+		go q.worker()
+	}
+}
+
+// Enqueue to add one batch to the queue of its environment
+func (q *LogQueue) Enqueue(job logJob) error {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	for !q.closed && q.full(job.environment) {
+		if !q.Config.Block {
+			q.rejected++
+			return ErrQueueFull
+		}
+		q.notFull.Wait()
+	}
+	if q.closed {
+		q.rejected++
+		return ErrQueueClosed
+	}
+	if len(q.pending[job.environment]) == 0 {
+		q.order = append(q.order, job.environment)
+	}
+	q.pending[job.environment] = append(q.pending[job.environment], job)
+	q.depth++
+	q.notEmpty.Signal()
+	return nil
+}
+
+// Helper to check if there is room for one more batch, must be called with the lock held
+func (q *LogQueue) full(environment string) bool {
+	return q.depth >= q.Config.Size || len(q.pending[environment]) >= q.Config.EnvSize
+}
+
+// Helper to take the next batch in round-robin order, must be called with the lock held
+func (q *LogQueue) dequeue() (logJob, bool) {
+	if len(q.order) == 0 {
+		return logJob{}, false
+	}
+	if q.next >= len(q.order) {
+		q.next = 0
+	}
+	env := q.order[q.next]
+	job := q.pending[env][0]
+	q.pending[env] = q.pending[env][1:]
+	if len(q.pending[env]) == 0 {
+		// Environment has nothing else pending, remove it from the rotation
+		delete(q.pending, env)
+		q.order = append(q.order[:q.next], q.order[q.next+1:]...)
+	} else {
+		q.next++
+	}
+	q.depth--
+	q.notFull.Broadcast()
+	return job, true
+}
+
+// Worker to drain the queue until it is closed and empty
+func (q *LogQueue) worker() {
+	defer q.workers.Done()
+	for {
+		q.mux.Lock()
+		job, ok := q.dequeue()
+		for !ok && !q.closed {
+			q.notEmpty.Wait()
+			job, ok = q.dequeue()
+		}
+		q.mux.Unlock()
+		if !ok {
+			return
+		}
+		q.process(job)
+		q.mux.Lock()
+		q.processed[job.environment]++
+		q.mux.Unlock()
+	}
+}
+
+// Stats to retrieve the current state of the queue and reset the per environment throughput
+func (q *LogQueue) Stats() QueueStats {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	stats := QueueStats{
+		Depth:     q.depth,
+		Rejected:  q.rejected,
+		Processed: q.processed,
+		Pending:   make(map[string]int),
+	}
+	for env, jobs := range q.pending {
+		stats.Pending[env] = len(jobs)
+	}
+	q.rejected = 0
+	q.processed = make(map[string]int)
+	return stats
+}
+
+// Shutdown to stop accepting batches and wait for the workers to drain the queue
+func (q *LogQueue) Shutdown(timeout time.Duration) error {
+	q.mux.Lock()
+	q.closed = true
+	q.notEmpty.Broadcast()
+	q.notFull.Broadcast()
+	q.mux.Unlock()
+	done := make(chan struct{})
+	go func() {
+		q.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+		q.mux.Lock()
+		lost := q.depth
+		q.mux.Unlock()
+		log.Printf("log queue drain timed out with %d pending batches", lost)
+		return fmt.Errorf("timeout draining log queue, %d batches pending", lost)
+	}
+}
+
+// StartQueue to dispatch logs asynchronously with a pool of workers
+func (logTLS *LoggerTLS) StartQueue(cfg QueueConfig) {
+	logTLS.Queue = CreateLogQueue(cfg, logTLS.processJob)
+	logTLS.Queue.Start()
+}
+
+// StopQueue to drain pending logs before shutting down
+func (logTLS *LoggerTLS) StopQueue(timeout time.Duration) error {
+	if logTLS.Queue == nil {
+		return nil
+	}
+	return logTLS.Queue.Shutdown(timeout)
+}
+
+// EnqueueLogs to queue status/result logs to be processed by the workers
+func (logTLS *LoggerTLS) EnqueueLogs(data json.RawMessage, logType, environment, ipaddress string, dataLen int, debug bool) error {
+	// Logs can wait in the queue, the clock skew of nodes is measured against when they arrived
+	received := time.Now()
+	if logTLS.Queue == nil {
+		go logTLS.ProcessLogs(data, logType, environment, ipaddress, dataLen, received, debug)
+		return nil
+	}
+	return logTLS.Queue.Enqueue(logJob{
+		kind:        jobLogs,
+		environment: environment,
+		data:        data,
+		logType:     logType,
+		ipaddress:   ipaddress,
+		dataLen:     dataLen,
+		received:    received,
+		debug:       debug,
+	})
+}
+
+// EnqueueQueryResult to queue on-demand query results to be processed by the workers
+func (logTLS *LoggerTLS) EnqueueQueryResult(queriesWrite types.QueryWriteRequest, environment string, envid uint, debug bool) error {
+	if logTLS.Queue == nil {
+		go logTLS.ProcessLogQueryResult(queriesWrite, envid, debug)
+		return nil
+	}
+	return logTLS.Queue.Enqueue(logJob{
+		kind:        jobQueryResult,
+		environment: environment,
+		queryWrite:  queriesWrite,
+		envid:       envid,
+		debug:       debug,
+	})
+}
+
+// Helper to process one job from the queue
+func (logTLS *LoggerTLS) processJob(job logJob) {
+	switch job.kind {
+	case jobLogs:
+		logTLS.ProcessLogs(job.data, job.logType, job.environment, job.ipaddress, job.dataLen, job.received, job.debug)
+	case jobQueryResult:
+		logTLS.ProcessLogQueryResult(job.queryWrite, job.envid, job.debug)
+	}
+}
@@ -0,0 +1,128 @@
+package logging
+
+import (
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Simulated time spent by the backend to store one batch
+const testBackendDelay = 2 * time.Millisecond
+
+func TestQueueRoundRobin(t *testing.T) {
+	var processed []string
+	q := CreateLogQueue(QueueConfig{Workers: 1, Size: 100}, func(job logJob) {
+		processed = append(processed, job.environment)
+	})
+	// Noisy environment enqueues first
+	for i := 0; i < 5; i++ {
+		if err := q.Enqueue(logJob{environment: "noisy"}); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+	if err := q.Enqueue(logJob{environment: "quiet"}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	q.Start()
+	if err := q.Shutdown(time.Second); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(processed) != 6 {
+		t.Fatalf("expected 6 processed batches, got %d", len(processed))
+	}
+	if processed[1] != "quiet" {
+		t.Errorf("expected quiet environment to be processed second, got %v", processed)
+	}
+}
+
+func TestQueueDropWhenFull(t *testing.T) {
+	q := CreateLogQueue(QueueConfig{Workers: 1, Size: 2}, func(job logJob) {})
+	for i := 0; i < 2; i++ {
+		if err := q.Enqueue(logJob{environment: "env"}); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+	if err := q.Enqueue(logJob{environment: "env"}); err != ErrQueueFull {
+		t.Errorf("expected ErrQueueFull, got %v", err)
+	}
+	stats := q.Stats()
+	if stats.Depth != 2 || stats.Rejected != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestQueueEnvSize(t *testing.T) {
+	q := CreateLogQueue(QueueConfig{Workers: 1, Size: 10, EnvSize: 1}, func(job logJob) {})
+	if err := q.Enqueue(logJob{environment: "noisy"}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := q.Enqueue(logJob{environment: "noisy"}); err != ErrQueueFull {
+		t.Errorf("expected ErrQueueFull, got %v", err)
+	}
+	if err := q.Enqueue(logJob{environment: "quiet"}); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestQueueShutdownDrains(t *testing.T) {
+	var mux sync.Mutex
+	count := 0
+	q := CreateLogQueue(QueueConfig{Workers: 2, Size: 100}, func(job logJob) {
+		mux.Lock()
+		count++
+		mux.Unlock()
+	})
+	q.Start()
+	for i := 0; i < 50; i++ {
+		_ = q.Enqueue(logJob{environment: "env"})
+	}
+	if err := q.Shutdown(time.Second); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if count != 50 {
+		t.Errorf("expected 50 processed batches, got %d", count)
+	}
+	if err := q.Enqueue(logJob{environment: "env"}); err != ErrQueueClosed {
+		t.Errorf("expected ErrQueueClosed, got %v", err)
+	}
+}
+
+// Helper to report the p99 of the collected latencies
+func reportP99(b *testing.B, latencies []time.Duration) {
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	if len(latencies) > 0 {
+		b.ReportMetric(float64(latencies[len(latencies)*99/100].Microseconds()), "p99-us")
+	}
+}
+
+// Handler latency when the backend work is done in the request goroutine
+func BenchmarkStormSync(b *testing.B) {
+	latencies := make([]time.Duration, b.N)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		start := time.Now()
+		time.Sleep(testBackendDelay)
+		latencies[i] = time.Since(start)
+	}
+	reportP99(b, latencies)
+}
+
+// Handler latency when the batch is queued and the backend work is done by workers
+func BenchmarkStormQueue(b *testing.B) {
+	q := CreateLogQueue(QueueConfig{Workers: DefaultQueueWorkers, Size: DefaultQueueSize}, func(job logJob) {
+		time.Sleep(testBackendDelay)
+	})
+	q.Start()
+	envs := []string{"noisy", "noisy", "noisy", "quiet"}
+	latencies := make([]time.Duration, b.N)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		start := time.Now()
+		_ = q.Enqueue(logJob{environment: envs[i%len(envs)]})
+		latencies[i] = time.Since(start)
+	}
+	b.StopTimer()
+	reportP99(b, latencies)
+	_ = q.Shutdown(DefaultQueueShutdown)
+}
@@ -2,14 +2,18 @@ package logging
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"fmt"
 	"log"
 	"net/http"
-	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/jmpsec/osctrl/settings"
 	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/utils"
 	"github.com/spf13/viper"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -19,14 +23,31 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 )
 
+// s3RotateCheck is the interval to check the age of the objects buffered by the S3 logger
+const s3RotateCheck = time.Second
+
 // LoggerS3 will be used to log data using S3
 type LoggerS3 struct {
 	S3Config  types.S3Configuration
 	AWSConfig aws.Config
 	Client    *s3.Client
 	Uploader  *manager.Uploader
+	Template  S3KeyTemplate
+	Batcher   *S3Batcher
 	Enabled   bool
 	Debug     bool
+	stats     S3Stats
+	statsMux  sync.Mutex
+	stop      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+}
+
+// S3Stats to count the objects and bytes written by the S3 logger for each environment
+type S3Stats struct {
+	Objects map[string]int
+	Bytes   map[string]int
+	Failed  int
 }
 
 // CreateLoggerS3 to initialize the logger
@@ -40,6 +61,13 @@ func CreateLoggerS3(s3Config types.S3Configuration) (*LoggerS3, error) {
 	if err != nil {
 		return nil, err
 	}
+	if s3Config.RotateMB < 0 || s3Config.RotateSeconds < 0 {
+		return nil, fmt.Errorf("invalid rotation of S3 objects, size and age can not be negative")
+	}
+	tmpl, err := ParseS3KeyTemplate(s3Config.KeyTemplate)
+	if err != nil {
+		return nil, err
+	}
 	client := s3.NewFromConfig(cfg)
 	uploader := manager.NewUploader(client)
 	l := &LoggerS3{
@@ -47,9 +75,16 @@ func CreateLoggerS3(s3Config types.S3Configuration) (*LoggerS3, error) {
 		AWSConfig: cfg,
 		Client:    client,
 		Uploader:  uploader,
+		Template:  tmpl,
+		Batcher:   NewS3Batcher(tmpl, s3Config.RotateMB, s3Config.RotateSeconds),
 		Enabled:   true,
 		Debug:     false,
 	}
+	if l.Batcher != nil {
+		l.stop = make(chan struct{})
+		l.stopped = make(chan struct{})
+		go l.rotate()
+	}
 	return l, nil
 }
 
@@ -86,17 +121,72 @@ func (logS3 *LoggerS3) Settings(mgr *settings.Settings) {
 
 // Send - Function that sends JSON logs to S3
 func (logS3 *LoggerS3) Send(logType string, data []byte, environment, uuid string, debug bool) {
-	ctx := context.Background()
+	logS3.SendName(logType, data, environment, uuid, "", debug)
+}
+
+// SendName - Function that sends JSON logs of a pack or query to S3. Result logs are split by name when
+// keys use the name, and logs are buffered when objects are rotated.
+func (logS3 *LoggerS3) SendName(logType string, data []byte, environment, uuid, name string, debug bool) {
 	if debug {
 		log.Printf("DebugService: Sending %d bytes to S3 for %s - %s", len(data), environment, uuid)
 	}
-	result, err := logS3.Uploader.Upload(ctx, &s3.PutObjectInput{
-		Bucket:        aws.String(logS3.S3Config.Bucket),
-		Key:           aws.String(environment + "/" + logType + "/" + uuid + ":" + strconv.FormatInt(time.Now().UnixMilli(), 10) + ".json"),
-		Body:          bytes.NewBuffer(data),
-		ContentLength: int64(len(data)),
-		ContentType:   aws.String(http.DetectContentType(data)),
+	requests := map[string][]byte{name: data}
+	if logType == types.ResultLog && name == "" && logS3.Template.Uses(S3VarName) {
+		requests = SplitResultsByName(data)
+	}
+	now := time.Now()
+	for n, d := range requests {
+		vars := S3KeyVars{Environment: environment, LogType: logType, Name: n, UUID: uuid}
+		if logS3.Batcher == nil {
+			logS3.write(S3Batch{Vars: vars, Opened: now, Data: d, Entries: 1}, debug)
+			continue
+		}
+		for _, batch := range logS3.Batcher.Add(vars, d, now) {
+			logS3.write(batch, debug)
+		}
+	}
+}
+
+// Bucket to get the bucket for the logs of an environment
+func (logS3 *LoggerS3) Bucket(environment string) string {
+	if b, ok := logS3.S3Config.Buckets[environment]; ok && b != "" {
+		return b
+	}
+	// Keys of maps are lowercase when loaded from the configuration file
+	if b, ok := logS3.S3Config.Buckets[strings.ToLower(environment)]; ok && b != "" {
+		return b
+	}
+	return logS3.S3Config.Bucket
+}
+
+// Helper to write one object with logs, compressed if configured
+func (logS3 *LoggerS3) write(batch S3Batch, debug bool) {
+	key := logS3.Template.Render(batch.Vars, batch.Opened, utils.GenKSUID())
+	body := batch.Data
+	contentType := http.DetectContentType(body)
+	if logS3.S3Config.Gzip {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(body); err != nil {
+			log.Printf("Error compressing data for s3 %s", err)
+		}
+		if err := zw.Close(); err != nil {
+			log.Printf("Error compressing data for s3 %s", err)
+		}
+		body = buf.Bytes()
+		contentType = "application/gzip"
+		if !strings.HasSuffix(key, ".gz") {
+			key += ".gz"
+		}
+	}
+	result, err := logS3.Uploader.Upload(context.Background(), &s3.PutObjectInput{
+		Bucket:        aws.String(logS3.Bucket(batch.Vars.Environment)),
+		Key:           aws.String(key),
+		Body:          bytes.NewBuffer(body),
+		ContentLength: int64(len(body)),
+		ContentType:   aws.String(contentType),
 	})
+	logS3.count(batch.Vars.Environment, len(body), err)
 	if err != nil {
 		log.Printf("Error sending data to s3 %s", err)
 	}
@@ -104,3 +194,59 @@ func (logS3 *LoggerS3) Send(logType string, data []byte, environment, uuid strin
 		log.Printf("DebugService: S3 Upload %+v", result)
 	}
 }
+
+// Helper to count a written object
+func (logS3 *LoggerS3) count(environment string, size int, err error) {
+	logS3.statsMux.Lock()
+	defer logS3.statsMux.Unlock()
+	if err != nil {
+		logS3.stats.Failed++
+		return
+	}
+	if logS3.stats.Objects == nil {
+		logS3.stats.Objects = make(map[string]int)
+		logS3.stats.Bytes = make(map[string]int)
+	}
+	logS3.stats.Objects[environment]++
+	logS3.stats.Bytes[environment] += size
+}
+
+// Stats to get the objects and bytes written since the last call
+func (logS3 *LoggerS3) Stats() S3Stats {
+	logS3.statsMux.Lock()
+	defer logS3.statsMux.Unlock()
+	stats := logS3.stats
+	logS3.stats = S3Stats{}
+	return stats
+}
+
+// Helper to write the buffered objects that reached their age, until the logger is closed
+func (logS3 *LoggerS3) rotate() {
+	defer close(logS3.stopped)
+	ticker := time.NewTicker(s3RotateCheck)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-logS3.stop:
+			return
+		case now := <-ticker.C:
+			for _, batch := range logS3.Batcher.Due(now) {
+				logS3.write(batch, logS3.Debug)
+			}
+		}
+	}
+}
+
+// Close - Function to write the buffered objects, logs sent after closing are written one request per object
+func (logS3 *LoggerS3) Close() {
+	if logS3.Batcher == nil {
+		return
+	}
+	logS3.closeOnce.Do(func() {
+		close(logS3.stop)
+		<-logS3.stopped
+		for _, batch := range logS3.Batcher.Drain() {
+			logS3.write(batch, logS3.Debug)
+		}
+	})
+}
@@ -0,0 +1,493 @@
+package logging
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/jmpsec/osctrl/types"
+	"gorm.io/gorm"
+)
+
+const (
+	// DefaultS3ImportBatch is the default number of log entries inserted in each batch
+	DefaultS3ImportBatch = 500
+	// DefaultS3ImportRetention is the default age in days of the oldest events imported
+	DefaultS3ImportRetention = 30
+	// MaxS3ImportUnmatched is the number of unmatched host identifiers kept for the report
+	MaxS3ImportUnmatched = 100
+	// s3ImportMaxLine is the longest line of an object, status logs with crash reports can be large
+	s3ImportMaxLine = 16 * 1024 * 1024
+)
+
+// S3ImportedObject to track the objects already imported from S3 into the DB logger, so imports can run again
+type S3ImportedObject struct {
+	gorm.Model
+	Bucket      string `gorm:"uniqueIndex:idx_s3_imported_object"`
+	Key         string `gorm:"uniqueIndex:idx_s3_imported_object"`
+	Environment string
+	LogType     string
+	Entries     int
+	Old         int
+	Unmatched   int
+	Malformed   int
+}
+
+// S3Object to hold one object listed from S3
+type S3Object struct {
+	Key  string
+	Size int64
+}
+
+// S3ObjectKey to hold the parts of the key of an object written by the S3 logger, <env>/<type>/<uuid>:<millis>.json
+type S3ObjectKey struct {
+	Environment string
+	LogType     string
+	UUID        string
+	Time        time.Time
+}
+
+// S3ObjectSource to list and read the objects written by the S3 logger
+type S3ObjectSource interface {
+	// List calls fn with every object under prefix after the key after, in the order of their keys
+	List(ctx context.Context, prefix, after string, fn func(S3Object) error) error
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+// S3ImportStore to keep the imported entries and the objects already imported
+type S3ImportStore interface {
+	Imported(bucket, key string) (bool, error)
+	// Save stores the entries and the tracked object together, so an object is imported once or not at all
+	Save(object S3ImportedObject, results []OsqueryResultData, statuses []OsqueryStatusData, batch int) error
+}
+
+// S3ImportStats to hold the progress of an import
+type S3ImportStats struct {
+	Objects   int            `json:"objects"`
+	Imported  int            `json:"imported"`
+	Skipped   int            `json:"skipped"`
+	Entries   int            `json:"entries"`
+	Old       int            `json:"old"`
+	Unmatched int            `json:"unmatched"`
+	Malformed int            `json:"malformed"`
+	Hosts     map[string]int `json:"unmatched_hosts,omitempty"`
+}
+
+// S3ImportCheckpoint to resume an import from the last object processed, saved to a file after each object
+type S3ImportCheckpoint struct {
+	Bucket      string        `json:"bucket"`
+	Prefix      string        `json:"prefix"`
+	Environment string        `json:"environment"`
+	After       string        `json:"after"`
+	Stats       S3ImportStats `json:"stats"`
+}
+
+// S3Import to import the status and result logs written by the S3 logger into the DB logger, with their original time
+type S3Import struct {
+	Source      S3ObjectSource
+	Store       S3ImportStore
+	Bucket      string
+	Prefix      string
+	Environment string
+	From        time.Time
+	To          time.Time
+	// Retention skips events older than this from now, 0 imports all of them
+	Retention time.Duration
+	Batch     int
+	// Node maps the host identifier of logs to the UUID of a node of the environment
+	Node func(identifier string) (string, bool)
+	// Checkpoint is the file to resume the import, empty to not resume
+	Checkpoint string
+	Progress   func(key string, stats S3ImportStats)
+	Now        func() time.Time
+}
+
+// S3Objects to read the objects of a bucket
+type S3Objects struct {
+	Client *s3.Client
+	Bucket string
+}
+
+// CreateS3Objects to read the objects of the bucket of the S3 logger, with its credentials
+func CreateS3Objects(s3Config types.S3Configuration) (*S3Objects, error) {
+	l, err := CreateLoggerS3(s3Config)
+	if err != nil {
+		return nil, err
+	}
+	return &S3Objects{Client: l.Client, Bucket: s3Config.Bucket}, nil
+}
+
+// List calls fn with every object under prefix after the key after, in the order of their keys
+func (o *S3Objects) List(ctx context.Context, prefix, after string, fn func(S3Object) error) error {
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(o.Bucket),
+		Prefix: aws.String(prefix),
+	}
+	if after != "" {
+		input.StartAfter = aws.String(after)
+	}
+	paginator := s3.NewListObjectsV2Paginator(o.Client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("ListObjectsV2 %v", err)
+		}
+		for _, obj := range page.Contents {
+			if err := fn(S3Object{Key: aws.ToString(obj.Key), Size: obj.Size}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Open to read one object
+func (o *S3Objects) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	out, err := o.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(o.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("GetObject %v", err)
+	}
+	return out.Body, nil
+}
+
+// DBImportStore to keep imported entries in the tables of the DB logger
+type DBImportStore struct {
+	DB *gorm.DB
+}
+
+// CreateDBImportStore to initialize the store, with the table of imported objects
+func CreateDBImportStore(db *gorm.DB) (*DBImportStore, error) {
+	for _, table := range []interface{}{&OsqueryStatusData{}, &OsqueryResultData{}, &S3ImportedObject{}} {
+		if err := db.AutoMigrate(table); err != nil {
+			return nil, fmt.Errorf("AutoMigrate %v", err)
+		}
+	}
+	return &DBImportStore{DB: db}, nil
+}
+
+// Imported to check if an object was already imported
+func (s *DBImportStore) Imported(bucket, key string) (bool, error) {
+	var count int64
+	if err := s.DB.Model(&S3ImportedObject{}).Where(&S3ImportedObject{Bucket: bucket, Key: key}).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// Save stores the entries and the tracked object in one transaction
+func (s *DBImportStore) Save(object S3ImportedObject, results []OsqueryResultData, statuses []OsqueryStatusData, batch int) error {
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		if len(results) > 0 {
+			if err := tx.CreateInBatches(&results, batch).Error; err != nil {
+				return fmt.Errorf("CreateInBatches results %v", err)
+			}
+		}
+		if len(statuses) > 0 {
+			if err := tx.CreateInBatches(&statuses, batch).Error; err != nil {
+				return fmt.Errorf("CreateInBatches statuses %v", err)
+			}
+		}
+		return tx.Create(&object).Error
+	})
+}
+
+// ParseS3ObjectKey to get the environment, type of logs, node and time of an object written by the S3 logger.
+// Keys can have any prefix before the environment.
+func ParseS3ObjectKey(key string) (S3ObjectKey, error) {
+	var k S3ObjectKey
+	parts := strings.Split(key, "/")
+	if len(parts) < 3 {
+		return k, fmt.Errorf("invalid key %s", key)
+	}
+	k.Environment = parts[len(parts)-3]
+	k.LogType = parts[len(parts)-2]
+	name := strings.TrimSuffix(parts[len(parts)-1], ".gz")
+	name = strings.TrimSuffix(name, path.Ext(name))
+	i := strings.LastIndex(name, ":")
+	if i <= 0 {
+		return k, fmt.Errorf("invalid object name %s", key)
+	}
+	millis, err := strconv.ParseInt(name[i+1:], 10, 64)
+	if err != nil {
+		return k, fmt.Errorf("invalid time in %s - %v", key, err)
+	}
+	k.UUID = strings.ToUpper(name[:i])
+	k.Time = time.UnixMilli(millis).UTC()
+	return k, nil
+}
+
+// LoadS3Checkpoint to read the checkpoint of an import, empty if the file does not exist
+func LoadS3Checkpoint(file string) (S3ImportCheckpoint, error) {
+	var cp S3ImportCheckpoint
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return cp, nil
+	}
+	if err != nil {
+		return cp, err
+	}
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return cp, fmt.Errorf("invalid checkpoint %s - %v", file, err)
+	}
+	return cp, nil
+}
+
+// Save to write the checkpoint of an import, replacing the file at once
+func (cp S3ImportCheckpoint) Save(file string) error {
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(file+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(file+".tmp", file)
+}
+
+// Helper to split the content of an object in logs, objects are one JSON array or one JSON log per line.
+// Returns the logs and the number of lines that are not valid JSON.
+func splitS3Logs(data []byte) ([]json.RawMessage, int) {
+	var logs []json.RawMessage
+	if err := json.Unmarshal(data, &logs); err == nil {
+		return logs, 0
+	}
+	logs = nil
+	malformed := 0
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), s3ImportMaxLine)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var batch []json.RawMessage
+		switch {
+		case line[0] == '[' && json.Unmarshal(line, &batch) == nil:
+			logs = append(logs, batch...)
+		case line[0] == '{' && json.Valid(line):
+			logs = append(logs, append(json.RawMessage{}, line...))
+		default:
+			malformed++
+		}
+	}
+	if scanner.Err() != nil {
+		malformed++
+	}
+	return logs, malformed
+}
+
+// Helper to record an event of a host identifier without node
+func (stats *S3ImportStats) unmatched(identifier string) {
+	stats.Unmatched++
+	if stats.Hosts == nil {
+		stats.Hosts = make(map[string]int)
+	}
+	if _, ok := stats.Hosts[identifier]; ok || len(stats.Hosts) < MaxS3ImportUnmatched {
+		stats.Hosts[identifier]++
+	}
+}
+
+// Helper to get the UUID of the node of a log, by the node that sent it in the key or by host identifier
+func (i *S3Import) node(identifier string, key S3ObjectKey) (string, bool) {
+	if uuid, ok := i.Node(key.UUID); ok {
+		return uuid, true
+	}
+	if identifier == "" {
+		return "", false
+	}
+	return i.Node(identifier)
+}
+
+// ParseObject to prepare the entries of the DB logger from the content of one object. Events older than the retention
+// and events of unknown nodes are skipped, the time of the node is used as creation time, or the time of the object.
+func (i *S3Import) ParseObject(key S3ObjectKey, data []byte, stats *S3ImportStats) (S3ImportedObject, []OsqueryResultData, []OsqueryStatusData) {
+	object := S3ImportedObject{Bucket: i.Bucket, Environment: key.Environment, LogType: key.LogType}
+	var results []OsqueryResultData
+	var statuses []OsqueryStatusData
+	var cutoff time.Time
+	if i.Retention > 0 {
+		cutoff = i.now().Add(-i.Retention)
+	}
+	logs, malformed := splitS3Logs(data)
+	object.Malformed = malformed
+	for _, raw := range logs {
+		var generic types.LogGenericData
+		if err := json.Unmarshal(raw, &generic); err != nil {
+			object.Malformed++
+			continue
+		}
+		created := types.AgentTime(generic.UnixTime, generic.CalendarTime)
+		if created.IsZero() {
+			created = key.Time
+		}
+		if !cutoff.IsZero() && created.Before(cutoff) {
+			object.Old++
+			continue
+		}
+		uuid, ok := i.node(generic.HostIdentifier, key)
+		if !ok {
+			object.Unmatched++
+			identifier := generic.HostIdentifier
+			if identifier == "" {
+				identifier = key.UUID
+			}
+			stats.unmatched(identifier)
+			continue
+		}
+		switch key.LogType {
+		case types.ResultLog:
+			var l types.LogResultData
+			if err := json.Unmarshal(raw, &l); err != nil {
+				object.Malformed++
+				continue
+			}
+			for _, entry := range resultEntries(l, uuid, key.Environment) {
+				entry.CreatedAt = created
+				entry.UpdatedAt = created
+				results = append(results, entry)
+			}
+		case types.StatusLog:
+			var l types.LogStatusData
+			if err := json.Unmarshal(raw, &l); err != nil {
+				object.Malformed++
+				continue
+			}
+			statuses = append(statuses, OsqueryStatusData{
+				Model:       gorm.Model{CreatedAt: created, UpdatedAt: created},
+				UUID:        uuid,
+				Environment: key.Environment,
+				Line:        l.Line,
+				Message:     l.Message,
+				Version:     l.Version,
+				Filename:    l.Filename,
+				Severity:    l.Severity,
+			})
+		}
+	}
+	object.Entries = len(results) + len(statuses)
+	stats.Entries += object.Entries
+	stats.Old += object.Old
+	stats.Malformed += object.Malformed
+	return object, results, statuses
+}
+
+// Helper to get the current time, it can be replaced for tests
+func (i *S3Import) now() time.Time {
+	if i.Now != nil {
+		return i.Now()
+	}
+	return time.Now()
+}
+
+// Helper to check if an object has to be imported, by environment, type of logs and time of the object
+func (i *S3Import) wanted(key S3ObjectKey) bool {
+	if key.Environment != i.Environment {
+		return false
+	}
+	if key.LogType != types.ResultLog && key.LogType != types.StatusLog {
+		return false
+	}
+	if !i.From.IsZero() && key.Time.Before(i.From) {
+		return false
+	}
+	if !i.To.IsZero() && !key.Time.Before(i.To) {
+		return false
+	}
+	return true
+}
+
+// Run to import all the objects, resuming from the checkpoint when it is for the same bucket, prefix and environment.
+// Objects already imported are skipped, so running it again only imports new objects.
+func (i *S3Import) Run(ctx context.Context) (S3ImportStats, error) {
+	var cp S3ImportCheckpoint
+	if i.Checkpoint != "" {
+		loaded, err := LoadS3Checkpoint(i.Checkpoint)
+		if err != nil {
+			return cp.Stats, err
+		}
+		if loaded.Bucket == i.Bucket && loaded.Prefix == i.Prefix && loaded.Environment == i.Environment {
+			cp = loaded
+		}
+	}
+	cp.Bucket, cp.Prefix, cp.Environment = i.Bucket, i.Prefix, i.Environment
+	if i.Batch <= 0 {
+		i.Batch = DefaultS3ImportBatch
+	}
+	err := i.Source.List(ctx, i.Prefix, cp.After, func(obj S3Object) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		cp.Stats.Objects++
+		if err := i.importObject(ctx, obj, &cp.Stats); err != nil {
+			return err
+		}
+		cp.After = obj.Key
+		if i.Checkpoint != "" {
+			if err := cp.Save(i.Checkpoint); err != nil {
+				return fmt.Errorf("error saving checkpoint %v", err)
+			}
+		}
+		if i.Progress != nil {
+			i.Progress(obj.Key, cp.Stats)
+		}
+		return nil
+	})
+	return cp.Stats, err
+}
+
+// Helper to import one object, unless it is not wanted or it was already imported
+func (i *S3Import) importObject(ctx context.Context, obj S3Object, stats *S3ImportStats) error {
+	key, err := ParseS3ObjectKey(obj.Key)
+	if err != nil || !i.wanted(key) {
+		stats.Skipped++
+		return nil
+	}
+	imported, err := i.Store.Imported(i.Bucket, obj.Key)
+	if err != nil {
+		return fmt.Errorf("error checking %s - %v", obj.Key, err)
+	}
+	if imported {
+		stats.Skipped++
+		return nil
+	}
+	body, err := i.Source.Open(ctx, obj.Key)
+	if err != nil {
+		return fmt.Errorf("error reading %s - %v", obj.Key, err)
+	}
+	defer body.Close()
+	var reader io.Reader = body
+	// Objects are compressed when the S3 logger uses gzip
+	if strings.HasSuffix(obj.Key, ".gz") {
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			return fmt.Errorf("error decompressing %s - %v", obj.Key, err)
+		}
+		defer zr.Close()
+		reader = zr
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return fmt.Errorf("error reading %s - %v", obj.Key, err)
+	}
+	object, results, statuses := i.ParseObject(key, data, stats)
+	object.Key = obj.Key
+	if err := i.Store.Save(object, results, statuses, i.Batch); err != nil {
+		return fmt.Errorf("error saving %s - %v", obj.Key, err)
+	}
+	stats.Imported++
+	return nil
+}
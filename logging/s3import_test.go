@@ -0,0 +1,211 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmpsec/osctrl/types"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testNodeA = "8D5B2A6E-4F5A-4C2B-9E0A-3B7C1D2E4F60"
+	testNodeB = "3F1E9C2A-7B4D-4E8F-A0C1-5D6E7F8A9B0C"
+	testNodeC = "0C1D2E3F-5A6B-4C7D-8E9F-A0B1C2D3E4F5"
+)
+
+// memoryObjects to test imports with objects in memory, read from fixtures
+type memoryObjects struct {
+	objects map[string]string
+	fail    string
+}
+
+func (m *memoryObjects) List(ctx context.Context, prefix, after string, fn func(S3Object) error) error {
+	var keys []string
+	for k := range m.objects {
+		if strings.HasPrefix(k, prefix) && k > after {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := fn(S3Object{Key: k}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *memoryObjects) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	if key == m.fail {
+		return nil, fmt.Errorf("connection reset")
+	}
+	data, err := os.ReadFile("testdata/" + m.objects[key])
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// memoryStore to keep imported entries in memory
+type memoryStore struct {
+	imported map[string]S3ImportedObject
+	results  []OsqueryResultData
+	statuses []OsqueryStatusData
+}
+
+func (m *memoryStore) Imported(bucket, key string) (bool, error) {
+	_, ok := m.imported[bucket+"/"+key]
+	return ok, nil
+}
+
+func (m *memoryStore) Save(object S3ImportedObject, results []OsqueryResultData, statuses []OsqueryStatusData, batch int) error {
+	if m.imported == nil {
+		m.imported = make(map[string]S3ImportedObject)
+	}
+	m.imported[object.Bucket+"/"+object.Key] = object
+	m.results = append(m.results, results...)
+	m.statuses = append(m.statuses, statuses...)
+	return nil
+}
+
+func testObjects() *memoryObjects {
+	return &memoryObjects{objects: map[string]string{
+		"corp/result/" + strings.ToLower(testNodeC) + ":1715428800000.json": "s3-result-lines.json",
+		"corp/result/" + testNodeA + ":1712000000000.json":                  "s3-result-array.json",
+		"corp/result/" + testNodeA + ":1715342400000.json":                  "s3-result-array.json",
+		"corp/status/" + testNodeA + ":1715342500000.json":                  "s3-status.json",
+		"corp/unknown.txt": "s3-status.json",
+		"dev/result/" + testNodeA + ":1715342400000.json": "s3-result-array.json",
+	}}
+}
+
+func testImport(source S3ObjectSource, store S3ImportStore, checkpoint string) *S3Import {
+	nodes := map[string]string{testNodeA: testNodeA, "laptop-1": testNodeA, testNodeB: testNodeB, "laptop-2": testNodeB}
+	return &S3Import{
+		Source:      source,
+		Store:       store,
+		Bucket:      "osquery-logs",
+		Environment: "corp",
+		From:        time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+		To:          time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC),
+		Retention:   DefaultS3ImportRetention * 24 * time.Hour,
+		Batch:       2,
+		Node: func(identifier string) (string, bool) {
+			uuid, ok := nodes[identifier]
+			return uuid, ok
+		},
+		Checkpoint: checkpoint,
+		Now:        func() time.Time { return time.Date(2024, 5, 31, 0, 0, 0, 0, time.UTC) },
+	}
+}
+
+func TestParseS3ObjectKey(t *testing.T) {
+	key, err := ParseS3ObjectKey("archive/corp/result/" + strings.ToLower(testNodeA) + ":1715342400000.json")
+	assert.NoError(t, err)
+	assert.Equal(t, S3ObjectKey{Environment: "corp", LogType: types.ResultLog, UUID: testNodeA, Time: time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)}, key)
+	key, err = ParseS3ObjectKey("corp/result/" + testNodeA + ":1715342400000.json.gz")
+	assert.NoError(t, err)
+	assert.Equal(t, testNodeA, key.UUID)
+	for _, invalid := range []string{"corp/unknown.txt", "corp/result/" + testNodeA + ".json", "corp/result/" + testNodeA + ":yesterday.json"} {
+		_, err := ParseS3ObjectKey(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestS3ImportParseObject(t *testing.T) {
+	i := testImport(nil, nil, "")
+	var stats S3ImportStats
+	// Result logs in one JSON array, in event, snapshot and batch formats
+	data, err := os.ReadFile("testdata/s3-result-array.json")
+	assert.NoError(t, err)
+	key := S3ObjectKey{Environment: "corp", LogType: types.ResultLog, UUID: testNodeA, Time: time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)}
+	object, results, statuses := i.ParseObject(key, data, &stats)
+	assert.Equal(t, 0, len(statuses))
+	assert.Equal(t, 5, len(results))
+	assert.Equal(t, S3ImportedObject{Bucket: "osquery-logs", Environment: "corp", LogType: types.ResultLog, Entries: 5}, object)
+	assert.Equal(t, types.ResultFormatEvent, results[0].Format)
+	assert.Equal(t, `{"username":"alice","uid":"501"}`, results[0].Columns)
+	assert.Equal(t, time.Unix(1715342400, 0).UTC(), results[0].CreatedAt)
+	assert.Equal(t, types.ResultFormatSnapshot, results[1].Format)
+	assert.Equal(t, time.Unix(1715342460, 0).UTC(), results[2].CreatedAt)
+	assert.Equal(t, types.ResultFormatBatch, results[3].Format)
+	assert.Equal(t, types.ResultActionRemoved, results[3].Action)
+	assert.Equal(t, types.ResultActionAdded, results[4].Action)
+	// Result logs one per line from a node that is gone, with malformed lines, unknown hosts and old events
+	data, err = os.ReadFile("testdata/s3-result-lines.json")
+	assert.NoError(t, err)
+	key.UUID = testNodeC
+	object, results, _ = i.ParseObject(key, data, &stats)
+	assert.Equal(t, S3ImportedObject{Bucket: "osquery-logs", Environment: "corp", LogType: types.ResultLog, Entries: 1, Old: 1, Unmatched: 2, Malformed: 2}, object)
+	assert.Equal(t, 1, len(results))
+	// Matched by the hostname in the host identifier
+	assert.Equal(t, testNodeB, results[0].UUID)
+	assert.Equal(t, `{"username":"bob"}`, results[0].Columns)
+	// Logs without host identifier are reported with the node of the object
+	assert.Equal(t, map[string]int{"ghost-host": 1, testNodeC: 1}, stats.Hosts)
+	// Status logs without time of the node get the time of the object
+	data, err = os.ReadFile("testdata/s3-status.json")
+	assert.NoError(t, err)
+	key.LogType = types.StatusLog
+	_, _, statuses = i.ParseObject(key, data, &stats)
+	assert.Equal(t, 2, len(statuses))
+	assert.Equal(t, time.Unix(1715342400, 0).UTC(), statuses[0].CreatedAt)
+	assert.Equal(t, key.Time, statuses[1].CreatedAt)
+	assert.Equal(t, testNodeA, statuses[1].UUID)
+	assert.Equal(t, "Config refresh failed", statuses[1].Message)
+	assert.Equal(t, S3ImportStats{Entries: 8, Old: 1, Unmatched: 2, Malformed: 2, Hosts: map[string]int{"ghost-host": 1, testNodeC: 1}}, stats)
+}
+
+func TestS3ImportRun(t *testing.T) {
+	checkpoint := filepath.Join(t.TempDir(), "import.json")
+	source := testObjects()
+	store := &memoryStore{}
+	// The import stops when an object can not be read, the checkpoint keeps what was done
+	source.fail = "corp/result/" + testNodeA + ":1715342400000.json"
+	_, err := testImport(source, store, checkpoint).Run(context.Background())
+	assert.Error(t, err)
+	cp, err := LoadS3Checkpoint(checkpoint)
+	assert.NoError(t, err)
+	assert.Equal(t, "corp/result/"+testNodeA+":1712000000000.json", cp.After)
+	assert.Equal(t, 1, cp.Stats.Imported)
+	assert.Equal(t, 1, cp.Stats.Skipped)
+	// Resumed from the checkpoint
+	source.fail = ""
+	var progress []string
+	i := testImport(source, store, checkpoint)
+	i.Progress = func(key string, stats S3ImportStats) {
+		progress = append(progress, key)
+	}
+	stats, err := i.Run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(progress))
+	assert.Equal(t, 6, stats.Objects)
+	assert.Equal(t, 3, stats.Imported)
+	assert.Equal(t, 3, stats.Skipped)
+	assert.Equal(t, 8, stats.Entries)
+	assert.Equal(t, 2, stats.Unmatched)
+	assert.Equal(t, 6, len(store.results))
+	assert.Equal(t, 2, len(store.statuses))
+	assert.Equal(t, 3, len(store.imported))
+	// Running it again without checkpoint does not import anything twice
+	stats, err = testImport(source, store, "").Run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, stats.Imported)
+	assert.Equal(t, 6, stats.Skipped)
+	assert.Equal(t, 6, len(store.results))
+	// A checkpoint of another import is ignored
+	i = testImport(source, &memoryStore{}, checkpoint)
+	i.Environment = "dev"
+	stats, err = i.Run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, stats.Imported)
+}
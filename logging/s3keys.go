@@ -0,0 +1,334 @@
+package logging
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultS3KeyTemplate is the layout of keys of objects written by the S3 logger without template
+	DefaultS3KeyTemplate = "{environment}/{log_type}/{uuid}:{timestamp}.json"
+	// DefaultS3RotateMB is the size of objects when only their age is configured
+	DefaultS3RotateMB = 64
+	// DefaultS3RotateSeconds is the age of objects when only their size is configured
+	DefaultS3RotateSeconds = 60
+	// Value of the name variable for logs without pack or query name, like status logs
+	s3EmptyName = "_"
+)
+
+// Variables of the key templates of the S3 logger
+const (
+	S3VarEnvironment = "environment"
+	S3VarLogType     = "log_type"
+	S3VarName        = "name"
+	S3VarUUID        = "uuid"
+	S3VarUUIDHash    = "uuid_hash"
+	S3VarDate        = "date"
+	S3VarYear        = "year"
+	S3VarMonth       = "month"
+	S3VarDay         = "day"
+	S3VarHour        = "hour"
+	S3VarTimestamp   = "timestamp"
+	S3VarID          = "id"
+)
+
+// S3KeyVariables to list all the variables of the key templates of the S3 logger
+var S3KeyVariables = []string{
+	S3VarEnvironment, S3VarLogType, S3VarName, S3VarUUID, S3VarUUIDHash,
+	S3VarDate, S3VarYear, S3VarMonth, S3VarDay, S3VarHour, S3VarTimestamp, S3VarID,
+}
+
+// Variables that depend on the logs, objects only buffer logs with the same values for them
+var s3DataVariables = []string{S3VarEnvironment, S3VarLogType, S3VarName, S3VarUUID, S3VarUUIDHash}
+
+// S3KeyVars to hold the values of the variables of a key that come from the logs
+type S3KeyVars struct {
+	Environment string
+	LogType     string
+	Name        string
+	UUID        string
+}
+
+// S3KeyTemplate to render the keys of objects written by the S3 logger
+type S3KeyTemplate struct {
+	Raw   string
+	parts []s3KeyPart
+}
+
+// Literal text or variable of a key template
+type s3KeyPart struct {
+	literal  string
+	variable string
+}
+
+// ParseS3KeyTemplate to validate a key template, an empty template is the default layout.
+// Templates must use {timestamp} or {id} so objects do not replace each other.
+func ParseS3KeyTemplate(tmpl string) (S3KeyTemplate, error) {
+	if strings.TrimSpace(tmpl) == "" {
+		tmpl = DefaultS3KeyTemplate
+	}
+	t := S3KeyTemplate{Raw: tmpl}
+	if strings.HasPrefix(tmpl, "/") {
+		return t, fmt.Errorf("key template %s can not start with /", tmpl)
+	}
+	rest := tmpl
+	for rest != "" {
+		open := strings.IndexAny(rest, "{}")
+		if open < 0 {
+			t.parts = append(t.parts, s3KeyPart{literal: rest})
+			break
+		}
+		if rest[open] == '}' {
+			return t, fmt.Errorf("unexpected } in key template %s", tmpl)
+		}
+		if open > 0 {
+			t.parts = append(t.parts, s3KeyPart{literal: rest[:open]})
+		}
+		end := strings.IndexAny(rest[open+1:], "{}")
+		if end < 0 || rest[open+1+end] != '}' {
+			return t, fmt.Errorf("unclosed variable in key template %s", tmpl)
+		}
+		variable := rest[open+1 : open+1+end]
+		if !validS3Variable(variable) {
+			return t, fmt.Errorf("unknown variable {%s} in key template %s", variable, tmpl)
+		}
+		t.parts = append(t.parts, s3KeyPart{variable: variable})
+		rest = rest[open+end+2:]
+	}
+	if !t.Uses(S3VarTimestamp) && !t.Uses(S3VarID) {
+		return t, fmt.Errorf("key template %s must use {%s} or {%s}", tmpl, S3VarTimestamp, S3VarID)
+	}
+	return t, nil
+}
+
+// Helper to check if a variable can be used in key templates
+func validS3Variable(variable string) bool {
+	for _, v := range S3KeyVariables {
+		if v == variable {
+			return true
+		}
+	}
+	return false
+}
+
+// Uses to check if a key template uses a variable
+func (t S3KeyTemplate) Uses(variable string) bool {
+	for _, p := range t.parts {
+		if p.variable == variable {
+			return true
+		}
+	}
+	return false
+}
+
+// S3UUIDHash to get the first two bytes of the SHA256 of a node UUID as hex, to spread keys between partitions
+func S3UUIDHash(uuid string) string {
+	h := sha256.Sum256([]byte(strings.ToUpper(uuid)))
+	return hex.EncodeToString(h[:2])
+}
+
+// Helper to get the value of a variable, values can not add levels to keys
+func (t S3KeyTemplate) value(variable string, vars S3KeyVars, now time.Time, id string) string {
+	now = now.UTC()
+	var v string
+	switch variable {
+	case S3VarEnvironment:
+		v = vars.Environment
+	case S3VarLogType:
+		v = vars.LogType
+	case S3VarName:
+		v = vars.Name
+		if v == "" {
+			v = s3EmptyName
+		}
+	case S3VarUUID:
+		v = vars.UUID
+	case S3VarUUIDHash:
+		v = S3UUIDHash(vars.UUID)
+	case S3VarDate:
+		v = now.Format("2006-01-02")
+	case S3VarYear:
+		v = now.Format("2006")
+	case S3VarMonth:
+		v = now.Format("01")
+	case S3VarDay:
+		v = now.Format("02")
+	case S3VarHour:
+		v = now.Format("15")
+	case S3VarTimestamp:
+		v = strconv.FormatInt(now.UnixMilli(), 10)
+	case S3VarID:
+		v = id
+	}
+	return strings.ReplaceAll(v, "/", "_")
+}
+
+// Render to get the key of an object with the values of the logs, the time of the object and its random id
+func (t S3KeyTemplate) Render(vars S3KeyVars, now time.Time, id string) string {
+	var b strings.Builder
+	for _, p := range t.parts {
+		if p.variable == "" {
+			b.WriteString(p.literal)
+			continue
+		}
+		b.WriteString(t.value(p.variable, vars, now, id))
+	}
+	return b.String()
+}
+
+// Group to get the values of the variables used by the template that come from the logs, logs with the
+// same group are buffered in the same object
+func (t S3KeyTemplate) Group(vars S3KeyVars) string {
+	var values []string
+	for _, v := range s3DataVariables {
+		if t.Uses(v) {
+			values = append(values, t.value(v, vars, time.Time{}, ""))
+		}
+	}
+	return strings.Join(values, "/")
+}
+
+// S3Batch to hold the logs buffered for one object of the S3 logger
+type S3Batch struct {
+	Vars    S3KeyVars
+	Opened  time.Time
+	Data    []byte
+	Entries int
+}
+
+// S3Batcher to buffer logs by their group and rotate objects by size and age
+type S3Batcher struct {
+	Template S3KeyTemplate
+	MaxBytes int
+	MaxAge   time.Duration
+	mutex    sync.Mutex
+	batches  map[string]*s3Buffer
+	drained  bool
+}
+
+// Logs buffered for one object
+type s3Buffer struct {
+	vars    S3KeyVars
+	opened  time.Time
+	data    bytes.Buffer
+	entries int
+}
+
+// NewS3Batcher to initialize the batcher with the rotation of the configuration, sizes in MB and ages in seconds.
+// Returns nil when objects are not rotated and every request is written as one object.
+func NewS3Batcher(t S3KeyTemplate, rotateMB, rotateSeconds int) *S3Batcher {
+	if rotateMB <= 0 && rotateSeconds <= 0 {
+		return nil
+	}
+	if rotateMB <= 0 {
+		rotateMB = DefaultS3RotateMB
+	}
+	if rotateSeconds <= 0 {
+		rotateSeconds = DefaultS3RotateSeconds
+	}
+	return &S3Batcher{
+		Template: t,
+		MaxBytes: rotateMB << 20,
+		MaxAge:   time.Duration(rotateSeconds) * time.Second,
+		batches:  make(map[string]*s3Buffer),
+	}
+}
+
+// Helper to take a buffer out of the batcher, the caller holds the mutex
+func (b *S3Batcher) take(group string) S3Batch {
+	buf := b.batches[group]
+	delete(b.batches, group)
+	return S3Batch{Vars: buf.vars, Opened: buf.opened, Data: buf.data.Bytes(), Entries: buf.entries}
+}
+
+// Add to buffer one request of logs as one line, returns the object to write when it reaches the size.
+// Once drained, requests are returned as one object each.
+func (b *S3Batcher) Add(vars S3KeyVars, data []byte, now time.Time) []S3Batch {
+	group := b.Template.Group(vars)
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.drained {
+		return []S3Batch{{Vars: vars, Opened: now, Data: data, Entries: 1}}
+	}
+	buf, ok := b.batches[group]
+	if !ok {
+		buf = &s3Buffer{vars: vars, opened: now}
+		b.batches[group] = buf
+	}
+	if err := json.Compact(&buf.data, data); err != nil {
+		buf.data.Write(bytes.TrimSpace(data))
+	}
+	buf.data.WriteByte('\n')
+	buf.entries++
+	if buf.data.Len() >= b.MaxBytes {
+		return []S3Batch{b.take(group)}
+	}
+	return nil
+}
+
+// Due to get the objects that reached their age
+func (b *S3Batcher) Due(now time.Time) []S3Batch {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	var res []S3Batch
+	for group, buf := range b.batches {
+		if now.Sub(buf.opened) >= b.MaxAge {
+			res = append(res, b.take(group))
+		}
+	}
+	return res
+}
+
+// Drain to get all the objects and stop buffering
+func (b *S3Batcher) Drain() []S3Batch {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.drained = true
+	var res []S3Batch
+	for group := range b.batches {
+		res = append(res, b.take(group))
+	}
+	return res
+}
+
+// Pending to get how many objects are buffered
+func (b *S3Batcher) Pending() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return len(b.batches)
+}
+
+// SplitResultsByName to split a request of result logs by the name of their pack or query, so keys can
+// use the name. Logs that can not be parsed are kept in one request without name.
+func SplitResultsByName(data []byte) map[string][]byte {
+	var logs []json.RawMessage
+	if err := json.Unmarshal(data, &logs); err != nil {
+		return map[string][]byte{"": data}
+	}
+	byName := make(map[string][]json.RawMessage)
+	for _, l := range logs {
+		var r struct {
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(l, &r); err != nil {
+			r.Name = ""
+		}
+		byName[r.Name] = append(byName[r.Name], l)
+	}
+	res := make(map[string][]byte, len(byName))
+	for name, entries := range byName {
+		raw, err := json.Marshal(entries)
+		if err != nil {
+			return map[string][]byte{"": data}
+		}
+		res[name] = raw
+	}
+	return res
+}
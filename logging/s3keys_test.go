@@ -0,0 +1,143 @@
+package logging
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jmpsec/osctrl/types"
+	"github.com/stretchr/testify/assert"
+)
+
+var testS3Vars = S3KeyVars{Environment: "corp", LogType: types.ResultLog, Name: "pack_processes", UUID: "AAAA-1111"}
+
+var testS3Time = time.Date(2024, 5, 10, 7, 30, 0, 0, time.UTC)
+
+func TestParseS3KeyTemplateDefault(t *testing.T) {
+	tmpl, err := ParseS3KeyTemplate("")
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultS3KeyTemplate, tmpl.Raw)
+	key := tmpl.Render(testS3Vars, testS3Time, "id")
+	assert.Equal(t, "corp/result/AAAA-1111:1715326200000.json", key)
+	parsed, err := ParseS3ObjectKey(key)
+	assert.NoError(t, err)
+	assert.Equal(t, "AAAA-1111", parsed.UUID)
+	assert.Equal(t, testS3Time, parsed.Time)
+}
+
+func TestParseS3KeyTemplateInvalid(t *testing.T) {
+	for _, invalid := range []string{
+		"/osquery/{environment}/{timestamp}.json",
+		"osquery/{environment}/{timestamp.json",
+		"osquery/{environment}}/{timestamp}.json",
+		"osquery/{{environment}/{timestamp}.json",
+		"osquery/{env}/{timestamp}.json",
+		"osquery/{environment}/{date}.json",
+	} {
+		_, err := ParseS3KeyTemplate(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestS3KeyTemplateVariables(t *testing.T) {
+	expected := map[string]string{
+		S3VarEnvironment: "corp",
+		S3VarLogType:     types.ResultLog,
+		S3VarName:        "pack_processes",
+		S3VarUUID:        "AAAA-1111",
+		S3VarUUIDHash:    S3UUIDHash("aaaa-1111"),
+		S3VarDate:        "2024-05-10",
+		S3VarYear:        "2024",
+		S3VarMonth:       "05",
+		S3VarDay:         "10",
+		S3VarHour:        "07",
+		S3VarTimestamp:   "1715326200000",
+		S3VarID:          "2fT3Zk",
+	}
+	assert.Len(t, expected, len(S3KeyVariables))
+	for _, v := range S3KeyVariables {
+		tmpl, err := ParseS3KeyTemplate("lake/{" + v + "}/{id}")
+		assert.NoError(t, err, v)
+		assert.Equal(t, "lake/"+expected[v]+"/2fT3Zk", tmpl.Render(testS3Vars, testS3Time, "2fT3Zk"), v)
+	}
+	assert.Len(t, S3UUIDHash("AAAA-1111"), 4)
+}
+
+func TestS3KeyTemplateValues(t *testing.T) {
+	tmpl, err := ParseS3KeyTemplate("osquery/{environment}/{log_type}/{name}/{year}/{month}/{day}/{timestamp}.json")
+	assert.NoError(t, err)
+	vars := S3KeyVars{Environment: "dev/test", LogType: types.StatusLog, UUID: "AAAA-1111"}
+	// Values do not add levels and logs without name have a placeholder
+	assert.Equal(t, "osquery/dev_test/status/_/2024/05/10/1715326200000.json", tmpl.Render(vars, testS3Time.In(time.FixedZone("test", 3600)), ""))
+}
+
+func TestS3KeyTemplateGroup(t *testing.T) {
+	tmpl, err := ParseS3KeyTemplate("osquery/{environment}/{log_type}/{date}/{id}.json")
+	assert.NoError(t, err)
+	other := testS3Vars
+	other.UUID = "BBBB-2222"
+	other.Name = "users"
+	assert.Equal(t, "corp/result", tmpl.Group(testS3Vars))
+	assert.Equal(t, tmpl.Group(testS3Vars), tmpl.Group(other))
+	other.Environment = "dev"
+	assert.NotEqual(t, tmpl.Group(testS3Vars), tmpl.Group(other))
+	byNode, err := ParseS3KeyTemplate("")
+	assert.NoError(t, err)
+	assert.Equal(t, "corp/result/AAAA-1111", byNode.Group(testS3Vars))
+}
+
+func TestNewS3Batcher(t *testing.T) {
+	tmpl, _ := ParseS3KeyTemplate("")
+	assert.Nil(t, NewS3Batcher(tmpl, 0, 0))
+	b := NewS3Batcher(tmpl, 5, 0)
+	assert.Equal(t, 5<<20, b.MaxBytes)
+	assert.Equal(t, DefaultS3RotateSeconds*time.Second, b.MaxAge)
+	b = NewS3Batcher(tmpl, 0, 30)
+	assert.Equal(t, DefaultS3RotateMB<<20, b.MaxBytes)
+	assert.Equal(t, 30*time.Second, b.MaxAge)
+}
+
+func TestS3BatcherRotateSize(t *testing.T) {
+	tmpl, _ := ParseS3KeyTemplate("osquery/{environment}/{id}.json")
+	b := NewS3Batcher(tmpl, 1, 60)
+	b.MaxBytes = 30
+	assert.Empty(t, b.Add(testS3Vars, []byte(`[{"name": "a"}]`), testS3Time))
+	assert.Equal(t, 1, b.Pending())
+	full := b.Add(testS3Vars, []byte("[{\n\"name\": \"b\"}]"), testS3Time.Add(time.Second))
+	assert.Len(t, full, 1)
+	assert.Equal(t, "[{\"name\":\"a\"}]\n[{\"name\":\"b\"}]\n", string(full[0].Data))
+	assert.Equal(t, 2, full[0].Entries)
+	assert.Equal(t, testS3Time, full[0].Opened)
+	assert.Equal(t, 0, b.Pending())
+}
+
+func TestS3BatcherRotateAge(t *testing.T) {
+	tmpl, _ := ParseS3KeyTemplate("osquery/{environment}/{id}.json")
+	b := NewS3Batcher(tmpl, 1, 60)
+	dev := testS3Vars
+	dev.Environment = "dev"
+	assert.Empty(t, b.Add(testS3Vars, []byte(`[]`), testS3Time))
+	assert.Empty(t, b.Add(dev, []byte(`[]`), testS3Time.Add(30*time.Second)))
+	assert.Empty(t, b.Due(testS3Time.Add(59*time.Second)))
+	due := b.Due(testS3Time.Add(60 * time.Second))
+	assert.Len(t, due, 1)
+	assert.Equal(t, "corp", due[0].Vars.Environment)
+	assert.Equal(t, 1, b.Pending())
+	drained := b.Drain()
+	assert.Len(t, drained, 1)
+	assert.Equal(t, "dev", drained[0].Vars.Environment)
+	// Once drained, requests are not buffered
+	after := b.Add(testS3Vars, []byte(`[1]`), testS3Time)
+	assert.Len(t, after, 1)
+	assert.Equal(t, `[1]`, string(after[0].Data))
+	assert.Equal(t, 0, b.Pending())
+}
+
+func TestSplitResultsByName(t *testing.T) {
+	res := SplitResultsByName([]byte(`[{"name":"users","epoch":0},{"name":"procs"},{"name":"users","counter":1}]`))
+	assert.Len(t, res, 2)
+	assert.Equal(t, `[{"name":"users","epoch":0},{"name":"users","counter":1}]`, string(res["users"]))
+	assert.Equal(t, `[{"name":"procs"}]`, string(res["procs"]))
+	invalid := SplitResultsByName([]byte(`not json`))
+	assert.Equal(t, map[string][]byte{"": []byte(`not json`)}, invalid)
+	assert.Equal(t, `[1]`, string(SplitResultsByName([]byte(`[1]`))[""]))
+}
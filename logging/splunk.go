@@ -83,8 +83,8 @@ func (logSP *LoggerSplunk) Settings(mgr *settings.Settings) {
 	log.Printf("Setting Splunk logging settings\n")
 }
 
-// Send - Function that sends JSON logs to Splunk HTTP Event Collector
-func (logSP *LoggerSplunk) Send(logType string, data []byte, environment, uuid string, debug bool) {
+// Send - Function that sends JSON logs to Splunk HTTP Event Collector, retrying failures that are not permanent
+func (logSP *LoggerSplunk) Send(logType string, data []byte, environment, uuid string, debug bool) error {
 	if debug {
 		log.Printf("DebugService: Send %s via splunk", logType)
 	}
@@ -127,18 +127,17 @@ func (logSP *LoggerSplunk) Send(logType string, data []byte, environment, uuid s
 	// Serialize data for Splunk
 	jsonEvents, err := json.Marshal(events)
 	if err != nil {
-		log.Printf("Error parsing data %s", err)
+		return &DeliveryError{Permanent: true, Reason: ReasonRejected, Err: err}
 	}
-	jsonParam := strings.NewReader(string(jsonEvents))
 	if debug {
 		log.Printf("DebugService: Sending %d bytes to Splunk for %s - %s", len(data), environment, uuid)
 	}
 	// Send log with a POST to the Splunk URL
-	resp, body, err := utils.SendRequest(SplunkMethod, logSP.Configuration.URL, jsonParam, logSP.Headers)
-	if err != nil {
-		log.Printf("Error sending request %s", err)
-	}
-	if debug {
-		log.Printf("DebugService: HTTP %d %s", resp, body)
-	}
+	return Deliver(func() error {
+		resp, body, err := utils.SendRequest(SplunkMethod, logSP.Configuration.URL, strings.NewReader(string(jsonEvents)), logSP.Headers)
+		if debug {
+			log.Printf("DebugService: HTTP %d %s", resp, body)
+		}
+		return HTTPDeliveryError(resp, body, err)
+	})
 }
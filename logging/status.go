@@ -0,0 +1,156 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jmpsec/osctrl/types"
+)
+
+const (
+	// DefaultStatusMaxMessage is the default maximum size in bytes of the message of one status log
+	DefaultStatusMaxMessage = 32768
+	// StatusTruncatedSuffix is appended to the messages of status logs that were truncated, with the bytes removed
+	StatusTruncatedSuffix = "... [truncated %d bytes]"
+)
+
+// StatusSummary to hold the outcome of sanitizing a batch of status logs
+type StatusSummary struct {
+	Entries        int
+	Truncated      int
+	Skipped        int
+	HostIdentifier string
+}
+
+// Helper to remove NUL characters from all the strings of a decoded status log, databases reject them
+func stripNUL(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return strings.ReplaceAll(v, "\x00", "")
+	case map[string]interface{}:
+		for k, child := range v {
+			v[k] = stripNUL(child)
+		}
+	case []interface{}:
+		for i, child := range v {
+			v[i] = stripNUL(child)
+		}
+	}
+	return value
+}
+
+// TruncateStatusMessage to cut a message to the maximum size without splitting characters, with the suffix
+// that indicates how many bytes were removed. Returns true if the message was truncated.
+func TruncateStatusMessage(message string, maxMessage int) (string, bool) {
+	if maxMessage <= 0 || len(message) <= maxMessage {
+		return message, false
+	}
+	cut := maxMessage
+	for cut > 0 && !utf8.RuneStart(message[cut]) {
+		cut--
+	}
+	return message[:cut] + fmt.Sprintf(StatusTruncatedSuffix, len(message)-cut), true
+}
+
+// Helper to sanitize one status log, returns false if the entry can not be used
+func sanitizeStatus(raw json.RawMessage, maxMessage int) (map[string]interface{}, bool, bool) {
+	var entry map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&entry); err != nil || entry == nil {
+		return entry, false, false
+	}
+	stripNUL(entry)
+	var truncated bool
+	if message, ok := entry["message"].(string); ok {
+		entry["message"], truncated = TruncateStatusMessage(message, maxMessage)
+	}
+	// Entries must be parsed by all the loggers, check the types of the values
+	check, err := json.Marshal(entry)
+	if err != nil {
+		return entry, false, truncated
+	}
+	var status types.LogStatusData
+	if err := json.Unmarshal(check, &status); err != nil {
+		return entry, false, truncated
+	}
+	return entry, true, truncated
+}
+
+// SanitizeStatusLogs to parse each status log of a batch independently, so one entry that is not valid does not
+// lose the rest. Entries that can not be parsed are skipped, messages over maxMessage bytes are truncated and NUL
+// characters are removed. Invalid UTF-8 is replaced and the batch is serialized again without line breaks, so
+// multi-line messages are escaped for line oriented loggers.
+func SanitizeStatusLogs(data []byte, maxMessage int) ([]byte, StatusSummary, error) {
+	var summary StatusSummary
+	var raws []json.RawMessage
+	if err := json.Unmarshal(data, &raws); err != nil {
+		return nil, summary, fmt.Errorf("error parsing status logs - %v", err)
+	}
+	summary.Entries = len(raws)
+	entries := make([]map[string]interface{}, 0, len(raws))
+	for _, raw := range raws {
+		entry, ok, truncated := sanitizeStatus(raw, maxMessage)
+		if host, isString := entry["hostIdentifier"].(string); isString && summary.HostIdentifier == "" {
+			summary.HostIdentifier = host
+		}
+		if !ok {
+			summary.Skipped++
+			continue
+		}
+		if truncated {
+			summary.Truncated++
+		}
+		entries = append(entries, entry)
+	}
+	sanitized, err := json.Marshal(entries)
+	if err != nil {
+		return nil, summary, fmt.Errorf("error serializing status logs - %v", err)
+	}
+	return sanitized, summary, nil
+}
+
+// SetStatusMaxMessage to change the maximum size of the message of status logs, 0 uses the default
+func (logTLS *LoggerTLS) SetStatusMaxMessage(maxMessage int) {
+	if maxMessage <= 0 {
+		maxMessage = DefaultStatusMaxMessage
+	}
+	logTLS.statusMutex.Lock()
+	defer logTLS.statusMutex.Unlock()
+	logTLS.statusMax = maxMessage
+}
+
+// Helper to sanitize status logs with the configured limit and count the entries truncated or skipped
+func (logTLS *LoggerTLS) sanitizeStatus(data []byte, environment string) ([]byte, StatusSummary, error) {
+	logTLS.statusMutex.Lock()
+	maxMessage := logTLS.statusMax
+	logTLS.statusMutex.Unlock()
+	if maxMessage <= 0 {
+		maxMessage = DefaultStatusMaxMessage
+	}
+	sanitized, summary, err := SanitizeStatusLogs(data, maxMessage)
+	if summary.Truncated > 0 || summary.Skipped > 0 {
+		log.Printf("status logs from %s:%s with %d entries truncated and %d skipped", environment, summary.HostIdentifier, summary.Truncated, summary.Skipped)
+	}
+	logTLS.statusMutex.Lock()
+	defer logTLS.statusMutex.Unlock()
+	logTLS.statusTruncated += summary.Truncated
+	logTLS.statusSkipped += summary.Skipped
+	if err != nil {
+		logTLS.statusInvalid++
+	}
+	return sanitized, summary, err
+}
+
+// StatusIssues to get the status logs truncated, skipped and the batches that could not be parsed, since the last call
+func (logTLS *LoggerTLS) StatusIssues() (int, int, int) {
+	logTLS.statusMutex.Lock()
+	defer logTLS.statusMutex.Unlock()
+	truncated, skipped, invalid := logTLS.statusTruncated, logTLS.statusSkipped, logTLS.statusInvalid
+	logTLS.statusTruncated, logTLS.statusSkipped, logTLS.statusInvalid = 0, 0, 0
+	return truncated, skipped, invalid
+}
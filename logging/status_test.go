@@ -0,0 +1,124 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/jmpsec/osctrl/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper to sanitize a fixture of status logs and parse it like the loggers do
+func sanitizeFixture(t *testing.T, file string, maxMessage int) ([]types.LogStatusData, []byte, StatusSummary) {
+	data, err := os.ReadFile("testdata/" + file)
+	assert.NoError(t, err)
+	sanitized, summary, err := SanitizeStatusLogs(data, maxMessage)
+	assert.NoError(t, err)
+	var logs []types.LogStatusData
+	assert.NoError(t, json.Unmarshal(sanitized, &logs))
+	return logs, sanitized, summary
+}
+
+func TestTruncateStatusMessage(t *testing.T) {
+	message, truncated := TruncateStatusMessage("short", 10)
+	assert.False(t, truncated)
+	assert.Equal(t, "short", message)
+	message, truncated = TruncateStatusMessage("0123456789abcdef", 10)
+	assert.True(t, truncated)
+	assert.Equal(t, "0123456789"+fmt.Sprintf(StatusTruncatedSuffix, 6), message)
+	// Characters are not split
+	message, truncated = TruncateStatusMessage("ééééé", 5)
+	assert.True(t, truncated)
+	assert.Equal(t, "éé"+fmt.Sprintf(StatusTruncatedSuffix, 6), message)
+	assert.True(t, utf8.ValidString(message))
+	// No limit
+	message, truncated = TruncateStatusMessage("0123456789", 0)
+	assert.False(t, truncated)
+	assert.Equal(t, "0123456789", message)
+}
+
+func TestSanitizeStatusHuge(t *testing.T) {
+	logs, sanitized, summary := sanitizeFixture(t, "status-huge.json", 1024)
+	assert.Equal(t, StatusSummary{Entries: 3, Truncated: 1, HostIdentifier: "3F1E9C2A-7B4D-4E8F-A0C1-5D6E7F8A9B0C"}, summary)
+	assert.Equal(t, 3, len(logs))
+	assert.Equal(t, "Executing scheduled query pack_incident-response_crontab", logs[0].Message)
+	assert.True(t, strings.HasPrefix(logs[1].Message, "Extension crashed: terminate called"))
+	assert.True(t, strings.HasSuffix(logs[1].Message, " bytes]"))
+	assert.True(t, len(logs[1].Message) < 1024+len(StatusTruncatedSuffix)+8)
+	assert.Equal(t, "Extension manager service starting", logs[2].Message)
+	// Multi-line messages are kept, escaped, so line oriented loggers write one line
+	assert.Contains(t, logs[1].Message, "\n    #1 0x")
+	assert.False(t, bytes.ContainsAny(sanitized, "\r\n"))
+}
+
+func TestSanitizeStatusMultiMegabyte(t *testing.T) {
+	entry := types.LogStatusData{
+		HostIdentifier: "3F1E9C2A-7B4D-4E8F-A0C1-5D6E7F8A9B0C",
+		Message:        strings.Repeat("E0308 14:25:00.000000 glog line from extension\n", 100000),
+		Filename:       "extensions.cpp",
+	}
+	data, err := json.Marshal([]types.LogStatusData{entry, {HostIdentifier: entry.HostIdentifier, Message: "ok"}})
+	assert.NoError(t, err)
+	assert.True(t, len(data) > 4*1024*1024)
+	sanitized, summary, err := SanitizeStatusLogs(data, DefaultStatusMaxMessage)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, summary.Truncated)
+	assert.True(t, len(sanitized) < 2*DefaultStatusMaxMessage)
+}
+
+func TestSanitizeStatusInvalidUTF8(t *testing.T) {
+	logs, _, summary := sanitizeFixture(t, "status-invalid-utf8.json", DefaultStatusMaxMessage)
+	assert.Equal(t, 2, summary.Entries)
+	assert.Equal(t, 0, summary.Skipped)
+	assert.Equal(t, 2, len(logs))
+	assert.True(t, utf8.ValidString(logs[0].Message))
+	assert.Equal(t, "invalid �� bytes in � extension name", logs[0].Message)
+}
+
+func TestSanitizeStatusNUL(t *testing.T) {
+	logs, sanitized, summary := sanitizeFixture(t, "status-nul.json", DefaultStatusMaxMessage)
+	assert.Equal(t, 0, summary.Skipped)
+	assert.Equal(t, 2, len(logs))
+	assert.Equal(t, "glog line with NUL characters", logs[0].Message)
+	assert.Equal(t, "watcher.cpp", logs[0].Filename)
+	assert.NotContains(t, string(sanitized), `\u0000`)
+}
+
+func TestSanitizeStatusMalformed(t *testing.T) {
+	logs, _, summary := sanitizeFixture(t, "status-malformed.json", DefaultStatusMaxMessage)
+	assert.Equal(t, StatusSummary{Entries: 5, Skipped: 3, HostIdentifier: "3F1E9C2A-7B4D-4E8F-A0C1-5D6E7F8A9B0C"}, summary)
+	assert.Equal(t, 2, len(logs))
+	assert.Equal(t, "Executing scheduled query pack_incident-response_crontab", logs[0].Message)
+	assert.Equal(t, "Extension manager service starting", logs[1].Message)
+	// Values not used by the loggers are kept
+	assert.Equal(t, "jenkins", logs[1].Decorations.Username)
+	// Batches that are not a list can not be sanitized
+	_, _, err := SanitizeStatusLogs([]byte(`{"message":"x"}`), DefaultStatusMaxMessage)
+	assert.Error(t, err)
+}
+
+func TestStatusIssues(t *testing.T) {
+	l := &LoggerTLS{}
+	l.SetStatusMaxMessage(1024)
+	data, err := os.ReadFile("testdata/status-huge.json")
+	assert.NoError(t, err)
+	_, _, err = l.sanitizeStatus(data, "dev")
+	assert.NoError(t, err)
+	data, err = os.ReadFile("testdata/status-malformed.json")
+	assert.NoError(t, err)
+	_, _, err = l.sanitizeStatus(data, "dev")
+	assert.NoError(t, err)
+	_, _, err = l.sanitizeStatus([]byte(`"not a batch"`), "dev")
+	assert.Error(t, err)
+	truncated, skipped, invalid := l.StatusIssues()
+	assert.Equal(t, 1, truncated)
+	assert.Equal(t, 3, skipped)
+	assert.Equal(t, 1, invalid)
+	truncated, skipped, invalid = l.StatusIssues()
+	assert.Equal(t, 0, truncated+skipped+invalid)
+}
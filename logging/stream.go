@@ -0,0 +1,310 @@
+package logging
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	// DefaultStreamEntries is the default number of log entries dispatched at once while streaming
+	DefaultStreamEntries = 1000
+	// DefaultStreamChunkBytes is the default size in bytes of the log entries dispatched at once while streaming
+	DefaultStreamChunkBytes = 4 * 1024 * 1024
+	// DefaultLogMaxBody is the default maximum size in bytes of the uncompressed body of log requests
+	DefaultLogMaxBody = 512 * 1024 * 1024
+)
+
+// ErrLogBodyTooLarge when the body of a log request goes over the maximum size while it is read
+var ErrLogBodyTooLarge = errors.New("log request body too large")
+
+// StreamConfig to limit the memory used to stream the logs of one request
+type StreamConfig struct {
+	// Maximum size in bytes of the body, 0 for no limit
+	MaxBody int64
+	// Maximum number of entries and bytes dispatched at once
+	Entries    int
+	ChunkBytes int
+}
+
+// StreamResult to know how far a log request was parsed and what was dispatched
+type StreamResult struct {
+	NodeKey string
+	LogType string
+	// Entries dispatched in chunks, entries that are not objects are skipped
+	Entries int
+	Skipped int
+	Chunks  int
+	// Bytes of the body read and offset of the decoder when it stopped
+	Read   int64
+	Offset int64
+}
+
+// LogChunk with some of the entries of a log request, as a JSON array
+type LogChunk struct {
+	Data    json.RawMessage
+	Entries int
+}
+
+// NewStreamConfig to get the limits to stream log requests, defaults if not set
+func NewStreamConfig(maxBody int64, entries, chunkBytes int) StreamConfig {
+	cfg := StreamConfig{MaxBody: maxBody, Entries: entries, ChunkBytes: chunkBytes}
+	if cfg.MaxBody <= 0 {
+		cfg.MaxBody = DefaultLogMaxBody
+	}
+	if cfg.Entries <= 0 {
+		cfg.Entries = DefaultStreamEntries
+	}
+	if cfg.ChunkBytes <= 0 {
+		cfg.ChunkBytes = DefaultStreamChunkBytes
+	}
+	return cfg
+}
+
+// limitReader to count the bytes read and fail as soon as they go over the maximum
+type limitReader struct {
+	r    io.Reader
+	max  int64
+	read int64
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	if l.max > 0 {
+		if l.read > l.max {
+			return 0, ErrLogBodyTooLarge
+		}
+		if left := l.max + 1 - l.read; int64(len(p)) > left {
+			p = p[:left]
+		}
+	}
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	if l.max > 0 && l.read > l.max {
+		return n, ErrLogBodyTooLarge
+	}
+	return n, err
+}
+
+// logStream to keep the state of one log request while it is decoded
+type logStream struct {
+	cfg     StreamConfig
+	dec     *json.Decoder
+	start   func(nodeKey, logType string) error
+	chunk   func(LogChunk) error
+	started bool
+	keys    int
+	buf     bytes.Buffer
+	entries int
+	// Chunks parsed before node_key and log_type are known, kept in a file until both are
+	spool  *os.File
+	result StreamResult
+}
+
+// StreamLogRequest to decode a log request incrementally and dispatch its entries in chunks, so the memory used
+// does not depend on the size of the batch. start is called once node_key and log_type are known, before any chunk.
+// Agents may send them after data, chunks parsed before are kept in a temporary file until then.
+// Chunks dispatched before an error are not undone, the result says how far the request was parsed.
+func StreamLogRequest(r io.Reader, cfg StreamConfig, start func(nodeKey, logType string) error, chunk func(LogChunk) error) (StreamResult, error) {
+	limited := &limitReader{r: r, max: cfg.MaxBody}
+	s := &logStream{cfg: cfg, dec: json.NewDecoder(limited), start: start, chunk: chunk}
+	err := s.decode()
+	if s.spool != nil {
+		s.spool.Close()
+		os.Remove(s.spool.Name())
+	}
+	s.result.Read = limited.read
+	s.result.Offset = s.dec.InputOffset()
+	return s.result, err
+}
+
+// Helper to decode the request object, the entries of data are streamed
+func (s *logStream) decode() error {
+	if err := s.delim('{'); err != nil {
+		return err
+	}
+	for s.dec.More() {
+		tok, err := s.dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case "node_key":
+			if err := s.dec.Decode(&s.result.NodeKey); err != nil {
+				return fmt.Errorf("node_key %w", err)
+			}
+			s.keys++
+		case "log_type":
+			if err := s.dec.Decode(&s.result.LogType); err != nil {
+				return fmt.Errorf("log_type %w", err)
+			}
+			s.keys++
+		case "data":
+			if err := s.data(); err != nil {
+				return err
+			}
+			continue
+		default:
+			var skip json.RawMessage
+			if err := s.dec.Decode(&skip); err != nil {
+				return err
+			}
+		}
+		if s.keys >= 2 {
+			if err := s.ready(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := s.delim('}'); err != nil {
+		return err
+	}
+	return s.ready()
+}
+
+// Helper to consume the expected delimiter
+func (s *logStream) delim(d json.Delim) error {
+	tok, err := s.dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != d {
+		return fmt.Errorf("expected %s and got %v", d, tok)
+	}
+	return nil
+}
+
+// Helper to stream the entries of data, dispatched in chunks
+func (s *logStream) data() error {
+	tok, err := s.dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok == nil {
+		return nil
+	}
+	if tok != json.Delim('[') {
+		return fmt.Errorf("data is not an array")
+	}
+	for s.dec.More() {
+		var entry json.RawMessage
+		if err := s.dec.Decode(&entry); err != nil {
+			// Entries parsed so far are dispatched
+			if ferr := s.flush(); ferr != nil {
+				return ferr
+			}
+			return err
+		}
+		if len(entry) == 0 || entry[0] != '{' {
+			s.result.Skipped++
+			continue
+		}
+		if s.entries == 0 {
+			s.buf.WriteByte('[')
+		} else {
+			s.buf.WriteByte(',')
+		}
+		s.buf.Write(entry)
+		s.entries++
+		if s.entries >= s.cfg.Entries || s.buf.Len() >= s.cfg.ChunkBytes {
+			if err := s.flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := s.flush(); err != nil {
+		return err
+	}
+	return s.delim(']')
+}
+
+// Helper to dispatch the current chunk, or to keep it until node_key and log_type are known
+func (s *logStream) flush() error {
+	if s.entries == 0 {
+		return nil
+	}
+	s.buf.WriteByte(']')
+	// Chunks are processed after the request is done, they can not share the buffer
+	data := make([]byte, s.buf.Len())
+	copy(data, s.buf.Bytes())
+	c := LogChunk{Data: data, Entries: s.entries}
+	var err error
+	if s.started {
+		err = s.dispatch(c)
+	} else {
+		err = s.keep(c)
+	}
+	s.buf.Reset()
+	s.entries = 0
+	return err
+}
+
+// Helper to dispatch one chunk
+func (s *logStream) dispatch(c LogChunk) error {
+	if err := s.chunk(c); err != nil {
+		return err
+	}
+	s.result.Entries += c.Entries
+	s.result.Chunks++
+	return nil
+}
+
+// Helper to keep one chunk in the spool file, prefixed with its entries and size
+func (s *logStream) keep(c LogChunk) error {
+	if s.spool == nil {
+		f, err := os.CreateTemp("", "osctrl-logs-*")
+		if err != nil {
+			return fmt.Errorf("spool %w", err)
+		}
+		s.spool = f
+	}
+	var header [8]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(c.Entries))
+	binary.BigEndian.PutUint32(header[4:], uint32(len(c.Data)))
+	if _, err := s.spool.Write(header[:]); err != nil {
+		return fmt.Errorf("spool %w", err)
+	}
+	if _, err := s.spool.Write(c.Data); err != nil {
+		return fmt.Errorf("spool %w", err)
+	}
+	return nil
+}
+
+// Helper to start dispatching once node_key and log_type are known, with the chunks kept until then
+func (s *logStream) ready() error {
+	if s.started {
+		return nil
+	}
+	s.started = true
+	if err := s.start(s.result.NodeKey, s.result.LogType); err != nil {
+		return err
+	}
+	if s.spool == nil {
+		return nil
+	}
+	if _, err := s.spool.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("spool %w", err)
+	}
+	r := bufio.NewReader(s.spool)
+	var header [8]byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("spool %w", err)
+		}
+		data := make([]byte, binary.BigEndian.Uint32(header[4:]))
+		if _, err := io.ReadFull(r, data); err != nil {
+			return fmt.Errorf("spool %w", err)
+		}
+		if err := s.dispatch(LogChunk{Data: data, Entries: int(binary.BigEndian.Uint32(header[:4]))}); err != nil {
+			return err
+		}
+	}
+	// Chunks are not kept anymore, the file is removed when the request is done
+	return s.spool.Truncate(0)
+}
@@ -0,0 +1,217 @@
+package logging
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jmpsec/osctrl/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper to collect the chunks of a log request
+func testStream(t *testing.T, body string, cfg StreamConfig) (StreamResult, []LogChunk, []string, error) {
+	var chunks []LogChunk
+	var started []string
+	res, err := StreamLogRequest(strings.NewReader(body), cfg, func(nodeKey, logType string) error {
+		started = append(started, nodeKey+":"+logType)
+		return nil
+	}, func(c LogChunk) error {
+		assert.Equal(t, 1, len(started), "chunk before start")
+		chunks = append(chunks, c)
+		return nil
+	})
+	return res, chunks, started, err
+}
+
+// Helper to generate the entries of a log request
+func testEntries(count int) string {
+	entries := make([]string, count)
+	for i := range entries {
+		entries[i] = fmt.Sprintf(`{"name":"pack_q","hostIdentifier":"AAAA","columns":{"pid":"%d"}}`, i)
+	}
+	return "[" + strings.Join(entries, ",") + "]"
+}
+
+func TestStreamLogRequestKeysFirst(t *testing.T) {
+	body := `{"node_key":"key1","log_type":"result","data":` + testEntries(5) + `}`
+	res, chunks, started, err := testStream(t, body, NewStreamConfig(0, 2, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"key1:result"}, started)
+	assert.Equal(t, 3, len(chunks))
+	assert.Equal(t, 5, res.Entries)
+	assert.Equal(t, 3, res.Chunks)
+	assert.Equal(t, int64(len(body)), res.Read)
+	var logs []types.LogResultData
+	assert.NoError(t, json.Unmarshal(chunks[2].Data, &logs))
+	assert.Equal(t, 1, len(logs))
+	assert.JSONEq(t, `{"pid":"4"}`, string(logs[0].Columns))
+}
+
+func TestStreamLogRequestDataFirst(t *testing.T) {
+	// osquery adds node_key after the data of the request
+	body := `{"data":` + testEntries(5) + `,"log_type":"status","node_key":"key1"}`
+	res, chunks, started, err := testStream(t, body, NewStreamConfig(0, 2, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"key1:status"}, started)
+	assert.Equal(t, 3, len(chunks))
+	assert.Equal(t, 5, res.Entries)
+	var logs []json.RawMessage
+	assert.NoError(t, json.Unmarshal(chunks[0].Data, &logs))
+	assert.Equal(t, 2, len(logs))
+}
+
+func TestStreamLogRequestSkipped(t *testing.T) {
+	body := `{"node_key":"key1","log_type":"result","data":[{"a":1},"oops",2,{"b":2}],"other":{"x":1}}`
+	res, chunks, _, err := testStream(t, body, NewStreamConfig(0, 0, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, res.Entries)
+	assert.Equal(t, 2, res.Skipped)
+	assert.Equal(t, `[{"a":1},{"b":2}]`, string(chunks[0].Data))
+}
+
+func TestStreamLogRequestPartial(t *testing.T) {
+	body := `{"node_key":"key1","log_type":"result","data":[{"a":1},{"a":2},{"a":3},{"a":`
+	res, chunks, _, err := testStream(t, body, NewStreamConfig(0, 2, 0))
+	assert.Error(t, err)
+	// Entries parsed before the failure are dispatched
+	assert.Equal(t, 3, res.Entries)
+	assert.Equal(t, 2, len(chunks))
+	assert.True(t, res.Offset > 0)
+	assert.True(t, res.Offset <= int64(len(body)))
+}
+
+func TestStreamLogRequestTooLarge(t *testing.T) {
+	body := `{"node_key":"key1","log_type":"result","data":` + testEntries(100) + `}`
+	res, chunks, _, err := testStream(t, body, NewStreamConfig(int64(len(body)/2), 10, 0))
+	assert.True(t, errors.Is(err, ErrLogBodyTooLarge))
+	assert.True(t, res.Read <= int64(len(body)/2)+1)
+	assert.True(t, res.Entries > 0)
+	assert.Equal(t, res.Chunks, len(chunks))
+	// The limit is exact
+	_, _, _, err = testStream(t, body, NewStreamConfig(int64(len(body)), 10, 0))
+	assert.NoError(t, err)
+}
+
+func TestStreamLogRequestStartError(t *testing.T) {
+	body := `{"data":` + testEntries(5) + `,"log_type":"result","node_key":"invalid"}`
+	res, err := StreamLogRequest(strings.NewReader(body), NewStreamConfig(0, 2, 0), func(nodeKey, logType string) error {
+		return fmt.Errorf("node %s not found", nodeKey)
+	}, func(c LogChunk) error {
+		t.Fatal("chunk dispatched for invalid node")
+		return nil
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 0, res.Entries)
+	assert.Equal(t, "invalid", res.NodeKey)
+}
+
+// syntheticBatch to generate a log request of a given size without keeping it in memory
+type syntheticBatch struct {
+	size    int64
+	read    int64
+	pending []byte
+	count   int
+	closed  bool
+}
+
+func (s *syntheticBatch) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(s.pending) == 0 {
+			switch {
+			case s.closed:
+				return n, io.EOF
+			case s.count == 0:
+				s.pending = []byte(`{"node_key":"key1","log_type":"result","data":[`)
+			case s.read >= s.size:
+				s.pending = []byte(`]}`)
+				s.closed = true
+			default:
+				prefix := ","
+				if s.count == 1 {
+					prefix = ""
+				}
+				s.pending = []byte(fmt.Sprintf(`%s{"name":"pack_processes","hostIdentifier":"AAAA-1111","calendarTime":"Mon Jan 1 00:00:00 2024 UTC","unixTime":1704067200,"action":"added","columns":{"pid":"%d","path":"/usr/bin/process-%d","cmdline":"/usr/bin/process --with arguments"}}`, prefix, s.count, s.count))
+			}
+			s.count++
+		}
+		c := copy(p[n:], s.pending)
+		s.pending = s.pending[c:]
+		s.read += int64(c)
+		n += c
+	}
+	return n, nil
+}
+
+// Helper to sample the peak of the heap while a function runs
+func peakHeap(f func()) uint64 {
+	runtime.GC()
+	var peak uint64
+	done := make(chan struct{})
+	go func() {
+		var m runtime.MemStats
+		for {
+			runtime.ReadMemStats(&m)
+			if m.HeapInuse > atomic.LoadUint64(&peak) {
+				atomic.StoreUint64(&peak, m.HeapInuse)
+			}
+			select {
+			case <-done:
+				return
+			case <-time.After(5 * time.Millisecond):
+			}
+		}
+	}()
+	f()
+	close(done)
+	return atomic.LoadUint64(&peak)
+}
+
+// Size of the synthetic batch, like agents after a long time offline
+const benchmarkBatch = 200 * 1024 * 1024
+
+// BenchmarkLogRequestReadAll reads and unmarshals the whole batch, like before streaming
+func BenchmarkLogRequestReadAll(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		peak := peakHeap(func() {
+			body, err := io.ReadAll(&syntheticBatch{size: benchmarkBatch})
+			if err != nil {
+				b.Fatal(err)
+			}
+			var t types.LogRequest
+			if err := json.Unmarshal(body, &t); err != nil {
+				b.Fatal(err)
+			}
+			var logs []types.LogGenericData
+			if err := json.Unmarshal(t.Data, &logs); err != nil {
+				b.Fatal(err)
+			}
+		})
+		b.ReportMetric(float64(peak)/(1024*1024), "peak-heap-MB")
+	}
+}
+
+// BenchmarkLogRequestStream decodes the batch incrementally, chunks are processed and dropped
+func BenchmarkLogRequestStream(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		peak := peakHeap(func() {
+			_, err := StreamLogRequest(&syntheticBatch{size: benchmarkBatch}, NewStreamConfig(0, 0, 0), func(nodeKey, logType string) error {
+				return nil
+			}, func(c LogChunk) error {
+				var logs []types.LogGenericData
+				return json.Unmarshal(c.Data, &logs)
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+		})
+		b.ReportMetric(float64(peak)/(1024*1024), "peak-heap-MB")
+	}
+}
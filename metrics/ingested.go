@@ -35,6 +35,8 @@ type IngestedData struct {
 	BytesIngested int
 	NodeID        uint
 	DataType      uint8
+	// Bytes received, smaller than the bytes ingested when nodes compress the data
+	BytesWire int
 }
 
 // IngestedManager to store and get ingested data
@@ -61,24 +63,30 @@ func (i *IngestedManager) Create(data *IngestedData) error {
 	return nil
 }
 
-// IngestGeneric to insert generic ingested data
+// IngestGeneric to insert generic ingested data, received as it is
 func (i *IngestedManager) IngestGeneric(env, node uint, bIngested int, ingestedType uint8) error {
+	return i.IngestWire(env, node, bIngested, bIngested, ingestedType)
+}
+
+// IngestWire to insert generic ingested data with the bytes received, that are fewer when it was compressed
+func (i *IngestedManager) IngestWire(env, node uint, bIngested, bWire int, ingestedType uint8) error {
 	d := IngestedData{
 		EnvironmentID: env,
 		BytesIngested: bIngested,
 		NodeID:        node,
 		DataType:      ingestedType,
+		BytesWire:     bWire,
 	}
 	return i.Create(&d)
 }
 
-// IngestLog to insert ingested new logs data
-func (i *IngestedManager) IngestLog(env, node uint, bIngested int, logType string) error {
+// IngestLog to insert ingested new logs data, with the bytes received
+func (i *IngestedManager) IngestLog(env, node uint, bIngested, bWire int, logType string) error {
 	switch logType {
 	case types.ResultLog:
-		return i.IngestGeneric(env, node, bIngested, uint8(IngestedResult))
+		return i.IngestWire(env, node, bIngested, bWire, uint8(IngestedResult))
 	case types.StatusLog:
-		return i.IngestGeneric(env, node, bIngested, uint8(IngestedStatus))
+		return i.IngestWire(env, node, bIngested, bWire, uint8(IngestedStatus))
 	}
 	return fmt.Errorf("invalid log type %s", logType)
 }
@@ -98,9 +106,9 @@ func (i *IngestedManager) IngestQueryRead(env, node uint, bIngested int) error {
 	return i.IngestGeneric(env, node, bIngested, uint8(IngestedQueryRead))
 }
 
-// IngestQueryWrite to insert ingested on-demand query results
-func (i *IngestedManager) IngestQueryWrite(env, node uint, bIngested int) error {
-	return i.IngestGeneric(env, node, bIngested, uint8(IngestedQueryWrite))
+// IngestQueryWrite to insert ingested on-demand query results, with the bytes received
+func (i *IngestedManager) IngestQueryWrite(env, node uint, bIngested, bWire int) error {
+	return i.IngestWire(env, node, bIngested, bWire, uint8(IngestedQueryWrite))
 }
 
 // IngestConfig to insert ingested configuration requests
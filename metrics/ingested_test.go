@@ -46,13 +46,14 @@ func TestIngested(t *testing.T) {
 			BytesIngested: 12345,
 			NodeID:        222,
 			DataType:      0,
+			BytesWire:     1234,
 		}
 		data.CreatedAt = tt
 		data.UpdatedAt = tt
 
 		mock.ExpectBegin()
 		mock.ExpectQuery(
-			regexp.QuoteMeta(`INSERT INTO "ingested_data" ("created_at","updated_at","deleted_at","environment_id","bytes_ingested","node_id","data_type") VALUES ($1,$2,$3,$4,$5,$6,$7) RETURNING "id"`)).WithArgs(tt, tt, nil, data.EnvironmentID, data.BytesIngested, data.NodeID, data.DataType).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(456))
+			regexp.QuoteMeta(`INSERT INTO "ingested_data" ("created_at","updated_at","deleted_at","environment_id","bytes_ingested","node_id","data_type","bytes_wire") VALUES ($1,$2,$3,$4,$5,$6,$7,$8) RETURNING "id"`)).WithArgs(tt, tt, nil, data.EnvironmentID, data.BytesIngested, data.NodeID, data.DataType, data.BytesWire).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(456))
 		mock.ExpectCommit()
 		err := manager.Create(data)
 
@@ -71,7 +72,7 @@ func TestIngested(t *testing.T) {
 
 		mock.ExpectBegin()
 		mock.ExpectQuery(
-			regexp.QuoteMeta(`INSERT INTO "ingested_data" ("created_at","updated_at","deleted_at","environment_id","bytes_ingested","node_id","data_type") VALUES ($1,$2,$3,$4,$5,$6,$7) RETURNING "id"`)).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, envID, bytesIngested, nodeID, dataType).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(456))
+			regexp.QuoteMeta(`INSERT INTO "ingested_data" ("created_at","updated_at","deleted_at","environment_id","bytes_ingested","node_id","data_type","bytes_wire") VALUES ($1,$2,$3,$4,$5,$6,$7,$8) RETURNING "id"`)).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, envID, bytesIngested, nodeID, dataType, bytesIngested).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(456))
 		mock.ExpectCommit()
 		err := manager.IngestGeneric(envID, nodeID, bytesIngested, dataType)
 
@@ -90,9 +91,9 @@ func TestIngested(t *testing.T) {
 
 		mock.ExpectBegin()
 		mock.ExpectQuery(
-			regexp.QuoteMeta(`INSERT INTO "ingested_data" ("created_at","updated_at","deleted_at","environment_id","bytes_ingested","node_id","data_type") VALUES ($1,$2,$3,$4,$5,$6,$7) RETURNING "id"`)).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, envID, bytesIngested, nodeID, dataType).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(456))
+			regexp.QuoteMeta(`INSERT INTO "ingested_data" ("created_at","updated_at","deleted_at","environment_id","bytes_ingested","node_id","data_type","bytes_wire") VALUES ($1,$2,$3,$4,$5,$6,$7,$8) RETURNING "id"`)).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, envID, bytesIngested, nodeID, dataType, bytesIngested).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(456))
 		mock.ExpectCommit()
-		err := manager.IngestLog(envID, nodeID, bytesIngested, types.ResultLog)
+		err := manager.IngestLog(envID, nodeID, bytesIngested, bytesIngested, types.ResultLog)
 
 		assert.NoError(t, err)
 
@@ -109,7 +110,7 @@ func TestIngested(t *testing.T) {
 
 		mock.ExpectBegin()
 		mock.ExpectQuery(
-			regexp.QuoteMeta(`INSERT INTO "ingested_data" ("created_at","updated_at","deleted_at","environment_id","bytes_ingested","node_id","data_type") VALUES ($1,$2,$3,$4,$5,$6,$7) RETURNING "id"`)).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, envID, bytesIngested, nodeID, dataType).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(456))
+			regexp.QuoteMeta(`INSERT INTO "ingested_data" ("created_at","updated_at","deleted_at","environment_id","bytes_ingested","node_id","data_type","bytes_wire") VALUES ($1,$2,$3,$4,$5,$6,$7,$8) RETURNING "id"`)).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, envID, bytesIngested, nodeID, dataType, bytesIngested).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(456))
 		mock.ExpectCommit()
 		err := manager.IngestStatus(envID, nodeID, bytesIngested)
 
@@ -128,7 +129,7 @@ func TestIngested(t *testing.T) {
 
 		mock.ExpectBegin()
 		mock.ExpectQuery(
-			regexp.QuoteMeta(`INSERT INTO "ingested_data" ("created_at","updated_at","deleted_at","environment_id","bytes_ingested","node_id","data_type") VALUES ($1,$2,$3,$4,$5,$6,$7) RETURNING "id"`)).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, envID, bytesIngested, nodeID, dataType).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(456))
+			regexp.QuoteMeta(`INSERT INTO "ingested_data" ("created_at","updated_at","deleted_at","environment_id","bytes_ingested","node_id","data_type","bytes_wire") VALUES ($1,$2,$3,$4,$5,$6,$7,$8) RETURNING "id"`)).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, envID, bytesIngested, nodeID, dataType, bytesIngested).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(456))
 		mock.ExpectCommit()
 		err := manager.IngestResult(envID, nodeID, bytesIngested)
 
@@ -147,7 +148,7 @@ func TestIngested(t *testing.T) {
 
 		mock.ExpectBegin()
 		mock.ExpectQuery(
-			regexp.QuoteMeta(`INSERT INTO "ingested_data" ("created_at","updated_at","deleted_at","environment_id","bytes_ingested","node_id","data_type") VALUES ($1,$2,$3,$4,$5,$6,$7) RETURNING "id"`)).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, envID, bytesIngested, nodeID, dataType).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(456))
+			regexp.QuoteMeta(`INSERT INTO "ingested_data" ("created_at","updated_at","deleted_at","environment_id","bytes_ingested","node_id","data_type","bytes_wire") VALUES ($1,$2,$3,$4,$5,$6,$7,$8) RETURNING "id"`)).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, envID, bytesIngested, nodeID, dataType, bytesIngested).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(456))
 		mock.ExpectCommit()
 		err := manager.IngestQueryRead(envID, nodeID, bytesIngested)
 
@@ -166,9 +167,9 @@ func TestIngested(t *testing.T) {
 
 		mock.ExpectBegin()
 		mock.ExpectQuery(
-			regexp.QuoteMeta(`INSERT INTO "ingested_data" ("created_at","updated_at","deleted_at","environment_id","bytes_ingested","node_id","data_type") VALUES ($1,$2,$3,$4,$5,$6,$7) RETURNING "id"`)).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, envID, bytesIngested, nodeID, dataType).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(456))
+			regexp.QuoteMeta(`INSERT INTO "ingested_data" ("created_at","updated_at","deleted_at","environment_id","bytes_ingested","node_id","data_type","bytes_wire") VALUES ($1,$2,$3,$4,$5,$6,$7,$8) RETURNING "id"`)).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, envID, bytesIngested, nodeID, dataType, bytesIngested).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(456))
 		mock.ExpectCommit()
-		err := manager.IngestQueryWrite(envID, nodeID, bytesIngested)
+		err := manager.IngestQueryWrite(envID, nodeID, bytesIngested, bytesIngested)
 
 		assert.NoError(t, err)
 
@@ -185,7 +186,7 @@ func TestIngested(t *testing.T) {
 
 		mock.ExpectBegin()
 		mock.ExpectQuery(
-			regexp.QuoteMeta(`INSERT INTO "ingested_data" ("created_at","updated_at","deleted_at","environment_id","bytes_ingested","node_id","data_type") VALUES ($1,$2,$3,$4,$5,$6,$7) RETURNING "id"`)).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, envID, bytesIngested, nodeID, dataType).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(456))
+			regexp.QuoteMeta(`INSERT INTO "ingested_data" ("created_at","updated_at","deleted_at","environment_id","bytes_ingested","node_id","data_type","bytes_wire") VALUES ($1,$2,$3,$4,$5,$6,$7,$8) RETURNING "id"`)).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, envID, bytesIngested, nodeID, dataType, bytesIngested).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(456))
 		mock.ExpectCommit()
 		err := manager.IngestConfig(envID, nodeID, bytesIngested)
 
@@ -204,7 +205,7 @@ func TestIngested(t *testing.T) {
 
 		mock.ExpectBegin()
 		mock.ExpectQuery(
-			regexp.QuoteMeta(`INSERT INTO "ingested_data" ("created_at","updated_at","deleted_at","environment_id","bytes_ingested","node_id","data_type") VALUES ($1,$2,$3,$4,$5,$6,$7) RETURNING "id"`)).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, envID, bytesIngested, nodeID, dataType).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(456))
+			regexp.QuoteMeta(`INSERT INTO "ingested_data" ("created_at","updated_at","deleted_at","environment_id","bytes_ingested","node_id","data_type","bytes_wire") VALUES ($1,$2,$3,$4,$5,$6,$7,$8) RETURNING "id"`)).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, envID, bytesIngested, nodeID, dataType, bytesIngested).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(456))
 		mock.ExpectCommit()
 		err := manager.IngestCarveInit(envID, nodeID, bytesIngested)
 
@@ -223,7 +224,7 @@ func TestIngested(t *testing.T) {
 
 		mock.ExpectBegin()
 		mock.ExpectQuery(
-			regexp.QuoteMeta(`INSERT INTO "ingested_data" ("created_at","updated_at","deleted_at","environment_id","bytes_ingested","node_id","data_type") VALUES ($1,$2,$3,$4,$5,$6,$7) RETURNING "id"`)).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, envID, bytesIngested, nodeID, dataType).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(456))
+			regexp.QuoteMeta(`INSERT INTO "ingested_data" ("created_at","updated_at","deleted_at","environment_id","bytes_ingested","node_id","data_type","bytes_wire") VALUES ($1,$2,$3,$4,$5,$6,$7,$8) RETURNING "id"`)).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, envID, bytesIngested, nodeID, dataType, bytesIngested).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(456))
 		mock.ExpectCommit()
 		err := manager.IngestCarveBlock(envID, nodeID, bytesIngested)
 
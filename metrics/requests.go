@@ -0,0 +1,345 @@
+package metrics
+
+import (
+	"math"
+	"math/bits"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// RequestWindow5m for request statistics of the last 5 minutes
+	RequestWindow5m string = "5m"
+	// RequestWindow1h for request statistics of the last hour
+	RequestWindow1h string = "1h"
+	// RequestWindow24h for request statistics of the last 24 hours
+	RequestWindow24h string = "24h"
+	// MaxRequestSeries is the maximum number of route and environment pairs kept, so memory is bounded
+	MaxRequestSeries int = 1024
+	// RequestOther is the environment of requests once all the series are in use
+	RequestOther string = "other"
+	// RequestUnknown is the environment of requests for environments that do not exist
+	RequestUnknown string = "unknown"
+	// Minute buckets, for the 5m and 1h windows
+	requestMinutes = 60
+	// Hour buckets, for the 24h window
+	requestHours = 24
+	// Latency buckets, each one doubles the upper bound of the one before
+	latencyBuckets = 24
+	// Upper bound of the first latency bucket
+	latencyBase = 50 * time.Microsecond
+)
+
+// RequestWindows to list the windows of request statistics, in display order
+var RequestWindows = []string{RequestWindow5m, RequestWindow1h, RequestWindow24h}
+
+// Requests of one minute or one hour
+type requestBucket struct {
+	slot     int64
+	requests uint64
+	errors   uint64
+	latency  int64
+	hist     [latencyBuckets]uint32
+}
+
+// Requests of one route in one environment
+type requestSeries struct {
+	mu      sync.Mutex
+	minutes [requestMinutes]requestBucket
+	hours   [requestHours]requestBucket
+}
+
+type requestKey struct {
+	route string
+	env   string
+}
+
+// RequestStats to keep statistics of requests by route and environment, with bounded memory.
+// Requests are counted in minute buckets for the last hour and in hour buckets for the last day.
+type RequestStats struct {
+	mu     sync.RWMutex
+	series map[requestKey]*requestSeries
+	// Now is the clock of the statistics, replaced in tests
+	Now func() time.Time
+}
+
+// RouteRequestStats to expose the statistics of one route in one environment for a window
+type RouteRequestStats struct {
+	Instance    string  `json:"instance,omitempty"`
+	Route       string  `json:"route"`
+	Environment string  `json:"environment"`
+	Requests    uint64  `json:"requests"`
+	Errors      uint64  `json:"errors"`
+	ErrorRate   float64 `json:"error_rate"`
+	MeanMs      float64 `json:"mean_ms"`
+	P50Ms       float64 `json:"p50_ms"`
+	P95Ms       float64 `json:"p95_ms"`
+	P99Ms       float64 `json:"p99_ms"`
+}
+
+// EnvRequestStats to expose the statistics of all the routes of one environment for a window
+type EnvRequestStats struct {
+	Instance    string  `json:"instance"`
+	Environment string  `json:"environment"`
+	Requests    uint64  `json:"requests"`
+	Errors      uint64  `json:"errors"`
+	ErrorRate   float64 `json:"error_rate"`
+	MeanMs      float64 `json:"mean_ms"`
+}
+
+// RequestSnapshot to expose the statistics of requests of one instance of a service
+type RequestSnapshot struct {
+	Instance string                         `json:"instance"`
+	Updated  time.Time                      `json:"updated"`
+	Windows  map[string][]RouteRequestStats `json:"windows"`
+}
+
+// NewRequestStats to initialize the statistics of requests
+func NewRequestStats() *RequestStats {
+	return &RequestStats{
+		series: make(map[requestKey]*requestSeries),
+		Now:    time.Now,
+	}
+}
+
+// Helper to get the latency bucket of a duration
+func latencyBucket(d time.Duration) int {
+	if d < 0 {
+		return 0
+	}
+	i := bits.Len64(uint64(d / latencyBase))
+	if i >= latencyBuckets {
+		return latencyBuckets - 1
+	}
+	return i
+}
+
+// Helper to get the upper bound of a latency bucket
+func latencyUpper(i int) time.Duration {
+	return latencyBase << uint(i)
+}
+
+// Helper to count one request in a bucket, reusing it when it belongs to an older slot
+func (b *requestBucket) add(slot int64, latency time.Duration, failed bool, hist int) {
+	if b.slot != slot {
+		*b = requestBucket{slot: slot}
+	}
+	b.requests++
+	if failed {
+		b.errors++
+	}
+	b.latency += int64(latency)
+	b.hist[hist]++
+}
+
+// Helper to get the series of a route and an environment, creating it if there is room
+func (s *RequestStats) get(route, env string) *requestSeries {
+	k := requestKey{route: route, env: env}
+	s.mu.RLock()
+	series, ok := s.series[k]
+	s.mu.RUnlock()
+	if ok {
+		return series
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if series, ok = s.series[k]; ok {
+		return series
+	}
+	if len(s.series) >= MaxRequestSeries {
+		k.env = RequestOther
+		if series, ok = s.series[k]; ok {
+			return series
+		}
+	}
+	series = &requestSeries{}
+	s.series[k] = series
+	return series
+}
+
+// Observe to count one request of a route in an environment with its status code and latency.
+// Requests with status codes of 400 and above are errors.
+func (s *RequestStats) Observe(route, env string, status int, latency time.Duration) {
+	now := s.Now().Unix()
+	hist := latencyBucket(latency)
+	failed := status >= 400
+	series := s.get(route, env)
+	series.mu.Lock()
+	series.minutes[(now/60)%requestMinutes].add(now/60, latency, failed, hist)
+	series.hours[(now/3600)%requestHours].add(now/3600, latency, failed, hist)
+	series.mu.Unlock()
+}
+
+// Helper to merge the buckets of a series in the slots of a window
+func mergeBuckets(buckets []requestBucket, from, to int64) requestBucket {
+	var total requestBucket
+	for _, b := range buckets {
+		if b.slot <= from || b.slot > to {
+			continue
+		}
+		total.requests += b.requests
+		total.errors += b.errors
+		total.latency += b.latency
+		for i := range b.hist {
+			total.hist[i] += b.hist[i]
+		}
+	}
+	return total
+}
+
+// Helper to get a percentile from the latency buckets, as the upper bound of the bucket
+func (b requestBucket) percentile(p float64) time.Duration {
+	if b.requests == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(p * float64(b.requests)))
+	var seen uint64
+	for i, c := range b.hist {
+		seen += uint64(c)
+		if seen >= rank {
+			return latencyUpper(i)
+		}
+	}
+	return latencyUpper(latencyBuckets - 1)
+}
+
+// Helper to convert a duration in milliseconds
+func toMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// Helper to get the statistics of a merged bucket
+func (b requestBucket) stats(k requestKey) RouteRequestStats {
+	r := RouteRequestStats{
+		Route:       k.route,
+		Environment: k.env,
+		Requests:    b.requests,
+		Errors:      b.errors,
+	}
+	if b.requests > 0 {
+		r.ErrorRate = float64(b.errors) / float64(b.requests)
+		r.MeanMs = toMs(time.Duration(b.latency / int64(b.requests)))
+		r.P50Ms = toMs(b.percentile(0.50))
+		r.P95Ms = toMs(b.percentile(0.95))
+		r.P99Ms = toMs(b.percentile(0.99))
+	}
+	return r
+}
+
+// Snapshot to get the statistics of all the windows, sorted by requests. Series without requests
+// in the last day are removed, so their room can be used by others.
+func (s *RequestStats) Snapshot(instance string) RequestSnapshot {
+	now := s.Now()
+	minute := now.Unix() / 60
+	hour := now.Unix() / 3600
+	snapshot := RequestSnapshot{
+		Instance: instance,
+		Updated:  now,
+		Windows:  make(map[string][]RouteRequestStats),
+	}
+	for _, w := range RequestWindows {
+		snapshot.Windows[w] = []RouteRequestStats{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, series := range s.series {
+		series.mu.Lock()
+		last5m := mergeBuckets(series.minutes[:], minute-5, minute)
+		last1h := mergeBuckets(series.minutes[:], minute-60, minute)
+		last24h := mergeBuckets(series.hours[:], hour-24, hour)
+		series.mu.Unlock()
+		if last24h.requests == 0 {
+			delete(s.series, k)
+			continue
+		}
+		if last5m.requests > 0 {
+			snapshot.Windows[RequestWindow5m] = append(snapshot.Windows[RequestWindow5m], last5m.stats(k))
+		}
+		if last1h.requests > 0 {
+			snapshot.Windows[RequestWindow1h] = append(snapshot.Windows[RequestWindow1h], last1h.stats(k))
+		}
+		snapshot.Windows[RequestWindow24h] = append(snapshot.Windows[RequestWindow24h], last24h.stats(k))
+	}
+	for _, stats := range snapshot.Windows {
+		sort.Slice(stats, func(i, j int) bool {
+			if stats[i].Requests != stats[j].Requests {
+				return stats[i].Requests > stats[j].Requests
+			}
+			if stats[i].Route != stats[j].Route {
+				return stats[i].Route < stats[j].Route
+			}
+			return stats[i].Environment < stats[j].Environment
+		})
+	}
+	return snapshot
+}
+
+// InstanceRows to get the statistics of a window of several instances, labeled with the instance
+func InstanceRows(snapshots []RequestSnapshot, window string) []RouteRequestStats {
+	rows := []RouteRequestStats{}
+	for _, snapshot := range snapshots {
+		for _, r := range snapshot.Windows[window] {
+			r.Instance = snapshot.Instance
+			rows = append(rows, r)
+		}
+	}
+	return rows
+}
+
+// TopErrorRoutes to get the routes with most errors, up to limit
+func TopErrorRoutes(rows []RouteRequestStats, limit int) []RouteRequestStats {
+	top := []RouteRequestStats{}
+	for _, r := range rows {
+		if r.Errors > 0 {
+			top = append(top, r)
+		}
+	}
+	sort.SliceStable(top, func(i, j int) bool {
+		return top[i].Errors > top[j].Errors
+	})
+	if len(top) > limit {
+		top = top[:limit]
+	}
+	return top
+}
+
+// SlowestRoutes to get the routes with the highest 95th percentile of latency, up to limit
+func SlowestRoutes(rows []RouteRequestStats, limit int) []RouteRequestStats {
+	slow := append([]RouteRequestStats{}, rows...)
+	sort.SliceStable(slow, func(i, j int) bool {
+		if slow[i].P95Ms != slow[j].P95Ms {
+			return slow[i].P95Ms > slow[j].P95Ms
+		}
+		return slow[i].MeanMs > slow[j].MeanMs
+	})
+	if len(slow) > limit {
+		slow = slow[:limit]
+	}
+	return slow
+}
+
+// EnvironmentRows to add up the routes of each environment of each instance
+func EnvironmentRows(rows []RouteRequestStats) []EnvRequestStats {
+	envRows := []EnvRequestStats{}
+	index := make(map[[2]string]int)
+	for _, r := range rows {
+		k := [2]string{r.Instance, r.Environment}
+		i, ok := index[k]
+		if !ok {
+			i = len(envRows)
+			index[k] = i
+			envRows = append(envRows, EnvRequestStats{Instance: r.Instance, Environment: r.Environment})
+		}
+		e := &envRows[i]
+		// Weighted by requests, so the mean is the mean of all the requests
+		e.MeanMs = (e.MeanMs*float64(e.Requests) + r.MeanMs*float64(r.Requests)) / float64(e.Requests+r.Requests)
+		e.Requests += r.Requests
+		e.Errors += r.Errors
+		e.ErrorRate = float64(e.Errors) / float64(e.Requests)
+	}
+	sort.SliceStable(envRows, func(i, j int) bool {
+		return envRows[i].Requests > envRows[j].Requests
+	})
+	return envRows
+}
@@ -0,0 +1,118 @@
+package metrics
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/test-go/testify/assert"
+)
+
+func TestLatencyBucket(t *testing.T) {
+	assert.Equal(t, 0, latencyBucket(10*time.Microsecond))
+	assert.Equal(t, 1, latencyBucket(50*time.Microsecond))
+	assert.Equal(t, 2, latencyBucket(150*time.Microsecond))
+	assert.Equal(t, latencyBuckets-1, latencyBucket(time.Hour))
+	assert.Equal(t, 0, latencyBucket(-time.Second))
+	assert.True(t, latencyUpper(latencyBucket(3*time.Millisecond)) >= 3*time.Millisecond)
+}
+
+func TestRequestStatsWindows(t *testing.T) {
+	now := time.Date(2023, 1, 10, 12, 0, 0, 0, time.UTC)
+	s := NewRequestStats()
+	s.Now = func() time.Time { return now }
+	// Two hours ago, only in the 24h window
+	now = now.Add(-2 * time.Hour)
+	s.Observe("/{environment}/osquery_config", "dev", 200, time.Millisecond)
+	// Thirty minutes ago, in the 1h and 24h windows
+	now = now.Add(90 * time.Minute)
+	s.Observe("/{environment}/osquery_config", "dev", 500, time.Millisecond)
+	// Now, in all windows
+	now = now.Add(30 * time.Minute)
+	for i := 0; i < 9; i++ {
+		s.Observe("/{environment}/osquery_config", "dev", 200, time.Millisecond)
+	}
+	s.Observe("/{environment}/osquery_config", "dev", 200, time.Second)
+	snapshot := s.Snapshot("tls-1")
+	assert.Equal(t, "tls-1", snapshot.Instance)
+	assert.Equal(t, uint64(10), snapshot.Windows[RequestWindow5m][0].Requests)
+	assert.Equal(t, uint64(0), snapshot.Windows[RequestWindow5m][0].Errors)
+	assert.Equal(t, uint64(11), snapshot.Windows[RequestWindow1h][0].Requests)
+	assert.Equal(t, uint64(1), snapshot.Windows[RequestWindow1h][0].Errors)
+	assert.Equal(t, uint64(12), snapshot.Windows[RequestWindow24h][0].Requests)
+	// Percentiles are the upper bound of the latency bucket
+	r := snapshot.Windows[RequestWindow5m][0]
+	assert.True(t, r.P50Ms >= 1 && r.P50Ms < 2.5)
+	assert.True(t, r.P99Ms >= 1000)
+	assert.InDelta(t, 100.9, r.MeanMs, 0.1)
+}
+
+func TestRequestStatsExpire(t *testing.T) {
+	now := time.Date(2023, 1, 10, 12, 0, 0, 0, time.UTC)
+	s := NewRequestStats()
+	s.Now = func() time.Time { return now }
+	s.Observe("/health", "", 200, time.Millisecond)
+	assert.Equal(t, 1, len(s.Snapshot("tls").Windows[RequestWindow24h]))
+	// Buckets are reused after a day and the series is removed
+	now = now.Add(25 * time.Hour)
+	assert.Equal(t, 0, len(s.Snapshot("tls").Windows[RequestWindow24h]))
+	assert.Equal(t, 0, len(s.series))
+}
+
+func TestRequestStatsBounded(t *testing.T) {
+	s := NewRequestStats()
+	for i := 0; i < MaxRequestSeries+10; i++ {
+		s.Observe("/{environment}/osquery_log", fmt.Sprintf("env%d", i), 200, time.Millisecond)
+	}
+	assert.Equal(t, MaxRequestSeries+1, len(s.series))
+	_, ok := s.series[requestKey{route: "/{environment}/osquery_log", env: RequestOther}]
+	assert.True(t, ok)
+}
+
+func TestRequestRows(t *testing.T) {
+	snapshots := []RequestSnapshot{
+		{Instance: "a", Windows: map[string][]RouteRequestStats{RequestWindow1h: {
+			{Route: "config", Environment: "dev", Requests: 10, Errors: 5, MeanMs: 2, P95Ms: 4},
+			{Route: "log", Environment: "dev", Requests: 30, MeanMs: 6, P95Ms: 10},
+		}}},
+		{Instance: "b", Windows: map[string][]RouteRequestStats{RequestWindow1h: {
+			{Route: "config", Environment: "dev", Requests: 5, Errors: 1, MeanMs: 1, P95Ms: 1},
+		}}},
+	}
+	rows := InstanceRows(snapshots, RequestWindow1h)
+	assert.Equal(t, 3, len(rows))
+	assert.Equal(t, "b", rows[2].Instance)
+	top := TopErrorRoutes(rows, 1)
+	assert.Equal(t, 1, len(top))
+	assert.Equal(t, "a", top[0].Instance)
+	assert.Equal(t, uint64(5), top[0].Errors)
+	slow := SlowestRoutes(rows, 10)
+	assert.Equal(t, "log", slow[0].Route)
+	envRows := EnvironmentRows(rows)
+	assert.Equal(t, 2, len(envRows))
+	assert.Equal(t, "a", envRows[0].Instance)
+	assert.Equal(t, uint64(40), envRows[0].Requests)
+	assert.InDelta(t, 5.0, envRows[0].MeanMs, 0.001)
+	assert.InDelta(t, 0.125, envRows[0].ErrorRate, 0.001)
+}
+
+func BenchmarkRequestStatsObserve(b *testing.B) {
+	s := NewRequestStats()
+	envs := []string{"dev", "prod", "corp"}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Observe("/{environment}/osquery_log", envs[i%len(envs)], 200, time.Millisecond)
+	}
+}
+
+func BenchmarkRequestStatsObserveParallel(b *testing.B) {
+	s := NewRequestStats()
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			s.Observe("/{environment}/osquery_config", "dev", 200, time.Millisecond)
+		}
+	})
+}
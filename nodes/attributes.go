@@ -0,0 +1,508 @@
+package nodes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// AttributeString for attributes with text values
+	AttributeString string = "string"
+	// AttributeNumber for attributes with numeric values
+	AttributeNumber string = "number"
+	// AttributeBool for attributes with boolean values
+	AttributeBool string = "bool"
+	// AttributeFilterPrefix to search nodes by attributes, like attr:vuln.risk_score>7
+	AttributeFilterPrefix string = "attr:"
+	// DefaultAttributesMaxBytes is the default size of the attributes of one node, keys and values
+	DefaultAttributesMaxBytes int = 8192
+)
+
+// Valid keys are a namespace and a name separated by a dot, like cmdb.owner
+var attributeKeyRe = regexp.MustCompile(`^([a-z0-9_-]+)\.([a-z0-9_.-]+)$`)
+
+// Operators of attribute filters, longer ones first so they are parsed before their prefixes
+var attributeOperators = []string{">=", "<=", "!=", "=", ">", "<"}
+
+// NodeAttribute to hold one custom attribute of a node, set by external systems
+type NodeAttribute struct {
+	gorm.Model
+	NodeID      uint   `gorm:"index"`
+	Namespace   string `gorm:"index"`
+	Name        string `gorm:"index"`
+	Type        string
+	StringValue string
+	NumberValue float64
+	BoolValue   bool
+	UpdatedBy   string
+}
+
+// AttributeFilter to select nodes by the value of one attribute, without operator it selects the nodes with it
+type AttributeFilter struct {
+	Name     string
+	Operator string
+	Value    string
+}
+
+// AttributeNamespace to validate the key of an attribute and get its namespace
+func AttributeNamespace(key string) (string, error) {
+	m := attributeKeyRe.FindStringSubmatch(key)
+	if m == nil {
+		return "", fmt.Errorf("invalid attribute %s, use namespace.name in lowercase", key)
+	}
+	return m[1], nil
+}
+
+// NewNodeAttribute to prepare an attribute from a key and a JSON value, string, number or boolean
+func NewNodeAttribute(key string, value interface{}) (NodeAttribute, error) {
+	namespace, err := AttributeNamespace(key)
+	if err != nil {
+		return NodeAttribute{}, err
+	}
+	a := NodeAttribute{Namespace: namespace, Name: key}
+	switch v := value.(type) {
+	case string:
+		a.Type = AttributeString
+		a.StringValue = v
+	case bool:
+		a.Type = AttributeBool
+		a.BoolValue = v
+	case float64:
+		a.Type = AttributeNumber
+		a.NumberValue = v
+	case int:
+		a.Type = AttributeNumber
+		a.NumberValue = float64(v)
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return NodeAttribute{}, fmt.Errorf("invalid number for %s - %v", key, err)
+		}
+		a.Type = AttributeNumber
+		a.NumberValue = f
+	default:
+		return NodeAttribute{}, fmt.Errorf("invalid value for %s, use a string, number or boolean", key)
+	}
+	return a, nil
+}
+
+// Value to get the typed value of an attribute
+func (a NodeAttribute) Value() interface{} {
+	switch a.Type {
+	case AttributeNumber:
+		return a.NumberValue
+	case AttributeBool:
+		return a.BoolValue
+	}
+	return a.StringValue
+}
+
+// String to get the value of an attribute as text
+func (a NodeAttribute) String() string {
+	switch a.Type {
+	case AttributeNumber:
+		return strconv.FormatFloat(a.NumberValue, 'f', -1, 64)
+	case AttributeBool:
+		return strconv.FormatBool(a.BoolValue)
+	}
+	return a.StringValue
+}
+
+// MarshalJSON to serialize attributes with their typed value
+func (a NodeAttribute) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Namespace string      `json:"namespace"`
+		Name      string      `json:"name"`
+		Type      string      `json:"type"`
+		Value     interface{} `json:"value"`
+		UpdatedBy string      `json:"updated_by"`
+		UpdatedAt time.Time   `json:"updated_at"`
+	}{a.Namespace, a.Name, a.Type, a.Value(), a.UpdatedBy, a.UpdatedAt})
+}
+
+// AttributesSize to get the size of attributes counted against the cap, keys and values
+func AttributesSize(attrs []NodeAttribute) int {
+	size := 0
+	for _, a := range attrs {
+		size += len(a.Name) + len(a.String())
+	}
+	return size
+}
+
+// AttributeValues to get the typed values of attributes by key
+func AttributeValues(attrs []NodeAttribute) map[string]interface{} {
+	values := make(map[string]interface{}, len(attrs))
+	for _, a := range attrs {
+		values[a.Name] = a.Value()
+	}
+	return values
+}
+
+// AttributesToString to get the attributes as key=value pairs sorted by key
+func AttributesToString(attrs []NodeAttribute) string {
+	pairs := make([]string, 0, len(attrs))
+	for _, a := range attrs {
+		pairs = append(pairs, a.Name+"="+a.String())
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, " ")
+}
+
+// MergeAttributes to apply changes to the current attributes of a node. Null values delete the attribute.
+// Every namespace changed must be allowed and the resulting attributes can not be bigger than the cap,
+// a cap of zero disables it. Returns the attributes to save and the keys to delete.
+func MergeAttributes(current []NodeAttribute, changes map[string]interface{}, allowed func(namespace string) bool, maxBytes int) ([]NodeAttribute, []string, error) {
+	merged := make(map[string]NodeAttribute, len(current))
+	for _, a := range current {
+		merged[a.Name] = a
+	}
+	var save []NodeAttribute
+	var remove []string
+	keys := make([]string, 0, len(changes))
+	for key := range changes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		namespace, err := AttributeNamespace(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		if allowed != nil && !allowed(namespace) {
+			return nil, nil, fmt.Errorf("no write access to namespace %s", namespace)
+		}
+		if changes[key] == nil {
+			if _, ok := merged[key]; ok {
+				delete(merged, key)
+				remove = append(remove, key)
+			}
+			continue
+		}
+		a, err := NewNodeAttribute(key, changes[key])
+		if err != nil {
+			return nil, nil, err
+		}
+		merged[key] = a
+		save = append(save, a)
+	}
+	all := make([]NodeAttribute, 0, len(merged))
+	for _, a := range merged {
+		all = append(all, a)
+	}
+	if size := AttributesSize(all); maxBytes > 0 && size > maxBytes {
+		return nil, nil, fmt.Errorf("attributes of %d bytes exceed the limit of %d bytes per node", size, maxBytes)
+	}
+	return save, remove, nil
+}
+
+// ParseAttributeFilter to parse a filter like vuln.risk_score>7, cmdb.owner=alice or cmdb.owner to select
+// the nodes with the attribute. The attr: prefix of searches is optional.
+func ParseAttributeFilter(filter string) (AttributeFilter, error) {
+	filter = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(filter), AttributeFilterPrefix))
+	f := AttributeFilter{Name: filter}
+	pos := -1
+	for _, op := range attributeOperators {
+		if i := strings.Index(filter, op); i > 0 && (pos < 0 || i < pos) {
+			pos = i
+			f.Operator = op
+		}
+	}
+	if pos > 0 {
+		f.Name = strings.TrimSpace(filter[:pos])
+		f.Value = strings.TrimSpace(filter[pos+len(f.Operator):])
+	}
+	f.Name = strings.ToLower(f.Name)
+	if _, err := AttributeNamespace(f.Name); err != nil {
+		return f, err
+	}
+	if f.Operator != "" && f.Value == "" {
+		return f, fmt.Errorf("missing value in attribute filter %s", filter)
+	}
+	return f, nil
+}
+
+// String to get the filter back in the format it is parsed
+func (f AttributeFilter) String() string {
+	return f.Name + f.Operator + f.Value
+}
+
+// Helper to get the value of a filter as a number, if it is one
+func (f AttributeFilter) number() (float64, bool) {
+	v, err := strconv.ParseFloat(f.Value, 64)
+	return v, err == nil
+}
+
+// Helper to compare ordered values with the operator of a filter
+func compareAttribute(op string, cmp int) bool {
+	switch op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	}
+	return false
+}
+
+// Match to check if one attribute matches the filter. Numbers are compared as numbers, booleans only
+// with = and != and text case insensitive.
+func (f AttributeFilter) Match(a NodeAttribute) bool {
+	if a.Name != f.Name {
+		return false
+	}
+	if f.Operator == "" {
+		return true
+	}
+	switch a.Type {
+	case AttributeNumber:
+		v, ok := f.number()
+		if !ok {
+			return false
+		}
+		cmp := 0
+		if a.NumberValue < v {
+			cmp = -1
+		} else if a.NumberValue > v {
+			cmp = 1
+		}
+		return compareAttribute(f.Operator, cmp)
+	case AttributeBool:
+		v, err := strconv.ParseBool(f.Value)
+		if err != nil || (f.Operator != "=" && f.Operator != "!=") {
+			return false
+		}
+		return (f.Operator == "=") == (a.BoolValue == v)
+	}
+	return compareAttribute(f.Operator, strings.Compare(strings.ToLower(a.StringValue), strings.ToLower(f.Value)))
+}
+
+// Matches to check if any of the attributes of a node matches the filter
+func (f AttributeFilter) Matches(attrs []NodeAttribute) bool {
+	for _, a := range attrs {
+		if f.Match(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// Condition to get the SQL condition on node_attributes for the attributes matching the filter
+func (f AttributeFilter) Condition() (string, []interface{}) {
+	condition := "deleted_at IS NULL AND name = ?"
+	args := []interface{}{f.Name}
+	if f.Operator == "" {
+		return condition, args
+	}
+	var typed []string
+	if v, ok := f.number(); ok {
+		typed = append(typed, "(type = '"+AttributeNumber+"' AND number_value "+f.Operator+" ?)")
+		args = append(args, v)
+	}
+	if v, err := strconv.ParseBool(f.Value); err == nil && (f.Operator == "=" || f.Operator == "!=") {
+		typed = append(typed, "(type = '"+AttributeBool+"' AND bool_value "+f.Operator+" ?)")
+		args = append(args, v)
+	}
+	typed = append(typed, "(type = '"+AttributeString+"' AND lower(string_value) "+f.Operator+" ?)")
+	args = append(args, strings.ToLower(f.Value))
+	return condition + " AND (" + strings.Join(typed, " OR ") + ")", args
+}
+
+// NodesCondition to get the SQL condition on osquery_nodes for the nodes with an attribute matching the filter
+func (f AttributeFilter) NodesCondition() (string, []interface{}) {
+	condition, args := f.Condition()
+	return "id IN (SELECT node_id FROM node_attributes WHERE " + condition + ")", args
+}
+
+// SortByAttribute to sort nodes by the value of an attribute, highest first and nodes without it last
+func SortByAttribute(nodes []OsqueryNode, attrs map[uint][]NodeAttribute, name string) {
+	value := func(n OsqueryNode) (NodeAttribute, bool) {
+		for _, a := range attrs[n.ID] {
+			if a.Name == name {
+				return a, true
+			}
+		}
+		return NodeAttribute{}, false
+	}
+	sort.SliceStable(nodes, func(i, j int) bool {
+		a, okA := value(nodes[i])
+		b, okB := value(nodes[j])
+		if !okA || !okB {
+			return okA && !okB
+		}
+		if a.Type == AttributeNumber && b.Type == AttributeNumber {
+			return a.NumberValue > b.NumberValue
+		}
+		return a.String() > b.String()
+	})
+}
+
+// FilterByAttributes to keep the nodes matching all the attribute filters
+func FilterByAttributes(nodes []OsqueryNode, attrs map[uint][]NodeAttribute, filters []AttributeFilter) []OsqueryNode {
+	if len(filters) == 0 {
+		return nodes
+	}
+	var filtered []OsqueryNode
+	for _, n := range nodes {
+		match := true
+		for _, f := range filters {
+			if !f.Matches(attrs[n.ID]) {
+				match = false
+				break
+			}
+		}
+		if match {
+			filtered = append(filtered, n)
+		}
+	}
+	return filtered
+}
+
+// GetAttributes to get the attributes of a node sorted by key
+func (n *NodeManager) GetAttributes(nodeID uint) ([]NodeAttribute, error) {
+	var attrs []NodeAttribute
+	if err := n.DB.Where("node_id = ?", nodeID).Order("name").Find(&attrs).Error; err != nil {
+		return attrs, fmt.Errorf("Find NodeAttribute %v", err)
+	}
+	return attrs, nil
+}
+
+// AttributesOfNodes to get the attributes of many nodes by node ID
+func (n *NodeManager) AttributesOfNodes(nodes []OsqueryNode) (map[uint][]NodeAttribute, error) {
+	res := make(map[uint][]NodeAttribute)
+	ids := make([]uint, 0, len(nodes))
+	for _, node := range nodes {
+		ids = append(ids, node.ID)
+	}
+	if len(ids) == 0 {
+		return res, nil
+	}
+	var attrs []NodeAttribute
+	if err := n.read().Where("node_id IN ?", ids).Order("name").Find(&attrs).Error; err != nil {
+		return res, fmt.Errorf("Find NodeAttribute %v", err)
+	}
+	for _, a := range attrs {
+		res[a.NodeID] = append(res[a.NodeID], a)
+	}
+	return res, nil
+}
+
+// SetAttributes to change the attributes of a node, null values delete the attribute
+func (n *NodeManager) SetAttributes(node OsqueryNode, changes map[string]interface{}, actor string, allowed func(namespace string) bool, maxBytes int) ([]NodeAttribute, error) {
+	current, err := n.GetAttributes(node.ID)
+	if err != nil {
+		return nil, err
+	}
+	save, remove, err := MergeAttributes(current, changes, allowed, maxBytes)
+	if err != nil {
+		return nil, err
+	}
+	err = n.DB.Transaction(func(tx *gorm.DB) error {
+		names := remove
+		for _, a := range save {
+			names = append(names, a.Name)
+		}
+		if len(names) > 0 {
+			if err := tx.Unscoped().Where("node_id = ? AND name IN ?", node.ID, names).Delete(&NodeAttribute{}).Error; err != nil {
+				return fmt.Errorf("Delete NodeAttribute %v", err)
+			}
+		}
+		for i := range save {
+			save[i].NodeID = node.ID
+			save[i].UpdatedBy = actor
+		}
+		if len(save) > 0 {
+			if err := tx.Create(&save).Error; err != nil {
+				return fmt.Errorf("Create NodeAttribute %v", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return n.GetAttributes(node.ID)
+}
+
+// DeleteAttributeNamespace to delete the attributes of a namespace of all nodes, returns how many were deleted
+func (n *NodeManager) DeleteAttributeNamespace(namespace string) (int64, error) {
+	if _, err := AttributeNamespace(namespace + ".x"); err != nil {
+		return 0, fmt.Errorf("invalid namespace %s", namespace)
+	}
+	res := n.DB.Unscoped().Where("namespace = ?", namespace).Delete(&NodeAttribute{})
+	if res.Error != nil {
+		return 0, fmt.Errorf("Delete NodeAttribute %v", res.Error)
+	}
+	return res.RowsAffected, nil
+}
+
+// AttributeNamespaces to get how many attributes each namespace has, across all nodes
+func (n *NodeManager) AttributeNamespaces() (map[string]int64, error) {
+	res := make(map[string]int64)
+	var rows []struct {
+		Namespace string
+		Total     int64
+	}
+	if err := n.read().Model(&NodeAttribute{}).Select("namespace, count(*) as total").Group("namespace").Scan(&rows).Error; err != nil {
+		return res, fmt.Errorf("Count NodeAttribute %v", err)
+	}
+	for _, r := range rows {
+		res[r.Namespace] = r.Total
+	}
+	return res, nil
+}
+
+// SearchAttributes to find nodes in the given environments with an attribute matching a filter, sorted by
+// the value of the attribute with the highest first
+func (n *NodeManager) SearchAttributes(ctx context.Context, filter AttributeFilter, envIDs []uint, limit int) ([]NodeMatch, error) {
+	matches := []NodeMatch{}
+	if len(envIDs) == 0 {
+		return matches, nil
+	}
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+	condition, args := filter.Condition()
+	var attrs []NodeAttribute
+	err := n.read().WithContext(ctx).Where(condition, args...).
+		Where("node_id IN (SELECT id FROM osquery_nodes WHERE deleted_at IS NULL AND environment_id IN ?)", envIDs).
+		Order("number_value DESC, string_value DESC").Limit(limit).Find(&attrs).Error
+	if err != nil {
+		return matches, fmt.Errorf("Find NodeAttribute %v", err)
+	}
+	if len(attrs) == 0 {
+		return matches, nil
+	}
+	ids := make([]uint, 0, len(attrs))
+	for _, a := range attrs {
+		ids = append(ids, a.NodeID)
+	}
+	var found []OsqueryNode
+	if err := n.read().WithContext(ctx).Where("id IN ?", ids).Find(&found).Error; err != nil {
+		return matches, fmt.Errorf("Find %v", err)
+	}
+	byID := make(map[uint]OsqueryNode, len(found))
+	for _, node := range found {
+		byID[node.ID] = node
+	}
+	for _, a := range attrs {
+		if node, ok := byID[a.NodeID]; ok {
+			matches = append(matches, nodeMatch(node, AttributeFilterPrefix+a.Name, a.String()))
+		}
+	}
+	return matches, nil
+}
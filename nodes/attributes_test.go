@@ -0,0 +1,112 @@
+package nodes
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewNodeAttribute(t *testing.T) {
+	a, err := NewNodeAttribute("cmdb.owner", "alice")
+	assert.NoError(t, err)
+	assert.Equal(t, "cmdb", a.Namespace)
+	assert.Equal(t, AttributeString, a.Type)
+	a, err = NewNodeAttribute("vuln.risk_score", 7.5)
+	assert.NoError(t, err)
+	assert.Equal(t, AttributeNumber, a.Type)
+	assert.Equal(t, "7.5", a.String())
+	a, err = NewNodeAttribute("cmdb.patch.wave-2", true)
+	assert.NoError(t, err)
+	assert.Equal(t, true, a.Value())
+	_, err = NewNodeAttribute("owner", "alice")
+	assert.Error(t, err)
+	_, err = NewNodeAttribute("CMDB.owner", "alice")
+	assert.Error(t, err)
+	_, err = NewNodeAttribute("cmdb.owners", []string{"alice"})
+	assert.Error(t, err)
+	raw, err := json.Marshal(NodeAttribute{Namespace: "vuln", Name: "vuln.risk_score", Type: AttributeNumber, NumberValue: 8})
+	assert.NoError(t, err)
+	assert.Contains(t, string(raw), `"value":8`)
+}
+
+func TestMergeAttributes(t *testing.T) {
+	current := []NodeAttribute{
+		{Namespace: "cmdb", Name: "cmdb.owner", Type: AttributeString, StringValue: "alice"},
+		{Namespace: "vuln", Name: "vuln.risk_score", Type: AttributeNumber, NumberValue: 3},
+	}
+	onlyCMDB := func(namespace string) bool { return namespace == "cmdb" }
+	save, remove, err := MergeAttributes(current, map[string]interface{}{"cmdb.owner": nil, "cmdb.criticality": "high"}, onlyCMDB, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"cmdb.owner"}, remove)
+	assert.Equal(t, 1, len(save))
+	assert.Equal(t, "cmdb.criticality", save[0].Name)
+	// Namespaces without write access can not be changed
+	_, _, err = MergeAttributes(current, map[string]interface{}{"vuln.risk_score": 9.0}, onlyCMDB, 0)
+	assert.EqualError(t, err, "no write access to namespace vuln")
+	// The cap counts the attributes that are kept
+	_, _, err = MergeAttributes(current, map[string]interface{}{"cmdb.notes": "a long note"}, nil, 40)
+	assert.Error(t, err)
+	_, _, err = MergeAttributes(current, map[string]interface{}{"vuln.risk_score": nil, "cmdb.notes": "a long note"}, nil, 40)
+	assert.NoError(t, err)
+}
+
+func TestParseAttributeFilter(t *testing.T) {
+	f, err := ParseAttributeFilter("attr:vuln.risk_score>7")
+	assert.NoError(t, err)
+	assert.Equal(t, AttributeFilter{Name: "vuln.risk_score", Operator: ">", Value: "7"}, f)
+	f, err = ParseAttributeFilter("vuln.risk_score >= 7")
+	assert.NoError(t, err)
+	assert.Equal(t, ">=", f.Operator)
+	f, err = ParseAttributeFilter("cmdb.owner!=alice")
+	assert.NoError(t, err)
+	assert.Equal(t, AttributeFilter{Name: "cmdb.owner", Operator: "!=", Value: "alice"}, f)
+	f, err = ParseAttributeFilter("cmdb.owner")
+	assert.NoError(t, err)
+	assert.Equal(t, "", f.Operator)
+	_, err = ParseAttributeFilter("attr:owner=alice")
+	assert.Error(t, err)
+	_, err = ParseAttributeFilter("cmdb.owner=")
+	assert.Error(t, err)
+}
+
+func TestAttributeFilterMatch(t *testing.T) {
+	score := NodeAttribute{Name: "vuln.risk_score", Type: AttributeNumber, NumberValue: 8}
+	owner := NodeAttribute{Name: "cmdb.owner", Type: AttributeString, StringValue: "Alice"}
+	critical := NodeAttribute{Name: "cmdb.critical", Type: AttributeBool, BoolValue: true}
+	match := func(filter string, a NodeAttribute) bool {
+		f, err := ParseAttributeFilter(filter)
+		assert.NoError(t, err)
+		return f.Match(a)
+	}
+	assert.True(t, match("vuln.risk_score>7", score))
+	assert.False(t, match("vuln.risk_score>8", score))
+	assert.True(t, match("vuln.risk_score<=8", score))
+	assert.False(t, match("vuln.risk_score>high", score))
+	assert.True(t, match("cmdb.owner=alice", owner))
+	assert.False(t, match("cmdb.owner!=alice", owner))
+	assert.True(t, match("cmdb.critical=true", critical))
+	assert.False(t, match("cmdb.critical!=true", critical))
+	assert.True(t, match("cmdb.critical", critical))
+	assert.False(t, match("cmdb.owner", critical))
+	f, _ := ParseAttributeFilter("vuln.risk_score>7")
+	condition, args := f.Condition()
+	assert.Equal(t, "deleted_at IS NULL AND name = ? AND ((type = 'number' AND number_value > ?) OR (type = 'string' AND lower(string_value) > ?))", condition)
+	assert.Equal(t, []interface{}{"vuln.risk_score", 7.0, "7"}, args)
+}
+
+func TestFilterAndSortByAttributes(t *testing.T) {
+	nodes := []OsqueryNode{{UUID: "a"}, {UUID: "b"}, {UUID: "c"}}
+	for i := range nodes {
+		nodes[i].ID = uint(i + 1)
+	}
+	attrs := map[uint][]NodeAttribute{
+		1: {{Name: "vuln.risk_score", Type: AttributeNumber, NumberValue: 3}},
+		2: {{Name: "vuln.risk_score", Type: AttributeNumber, NumberValue: 9}},
+	}
+	f, _ := ParseAttributeFilter("vuln.risk_score>=3")
+	filtered := FilterByAttributes(nodes, attrs, []AttributeFilter{f})
+	assert.Equal(t, 2, len(filtered))
+	SortByAttribute(nodes, attrs, "vuln.risk_score")
+	assert.Equal(t, []string{"b", "a", "c"}, []string{nodes[0].UUID, nodes[1].UUID, nodes[2].UUID})
+}
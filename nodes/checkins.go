@@ -0,0 +1,266 @@
+package nodes
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// CheckinHistoryHours is how many hours of check-ins are kept for each node, 7 days
+	CheckinHistoryHours int64 = 168
+	// DefaultCoverageWindow is the window of the coverage estimate when none is provided
+	DefaultCoverageWindow = 24 * time.Hour
+)
+
+// CheckinHistory to keep the hours of the last 7 days with check-ins of a node, as a ring of bits indexed by the
+// hour since epoch. It is updated incrementally in each check-in and written at most once per hour.
+type CheckinHistory struct {
+	// First hour with a check-in, 0 when there is no history
+	Since int64
+	// Last hour with a check-in
+	Hour int64
+	Bits [CheckinHistoryHours / 8]byte
+}
+
+// CoverageEstimate to estimate how many target nodes are expected to check in within a window, based on the
+// check-in history of each node
+type CoverageEstimate struct {
+	Environment string  `json:"environment"`
+	Window      string  `json:"window"`
+	WindowHours int64   `json:"window_hours"`
+	HistoryDays int64   `json:"history_days"`
+	Targets     int     `json:"targets"`
+	WithHistory int     `json:"with_history"`
+	Expected    float64 `json:"expected"`
+	Percent     int     `json:"percent"`
+	Summary     string  `json:"summary"`
+	GeneratedAt string  `json:"generated_at"`
+}
+
+// CheckinHour to get the hour since epoch of a time
+func CheckinHour(t time.Time) int64 {
+	return t.Unix() / 3600
+}
+
+// ParseCheckinHistory to parse the check-in history of a node, as <since>:<hour>:<hex bits>. Empty or invalid
+// values are an empty history.
+func ParseCheckinHistory(encoded string) CheckinHistory {
+	var c CheckinHistory
+	parts := strings.Split(encoded, ":")
+	if len(parts) != 3 {
+		return c
+	}
+	since, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return c
+	}
+	hour, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return c
+	}
+	bits, err := hex.DecodeString(parts[2])
+	if err != nil || len(bits) != len(c.Bits) {
+		return c
+	}
+	c.Since = since
+	c.Hour = hour
+	copy(c.Bits[:], bits)
+	return c
+}
+
+// Encode to serialize the check-in history to be stored with the node
+func (c CheckinHistory) Encode() string {
+	if c.Since == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d:%d:%s", c.Since, c.Hour, hex.EncodeToString(c.Bits[:]))
+}
+
+// Empty to check if there are no check-ins in the history
+func (c CheckinHistory) Empty() bool {
+	return c.Since == 0
+}
+
+// Helper to set or clear the bit of an hour
+func (c *CheckinHistory) set(hour int64, value bool) {
+	i := hour % CheckinHistoryHours
+	if value {
+		c.Bits[i/8] |= 1 << uint(i%8)
+	} else {
+		c.Bits[i/8] &^= 1 << uint(i%8)
+	}
+}
+
+// Seen to check if there was a check-in in one hour, hours outside of the last 7 days of the history are not seen
+func (c CheckinHistory) Seen(hour int64) bool {
+	if c.Since == 0 || hour > c.Hour || hour < c.Since || c.Hour-hour >= CheckinHistoryHours {
+		return false
+	}
+	i := hour % CheckinHistoryHours
+	return c.Bits[i/8]&(1<<uint(i%8)) != 0
+}
+
+// Record to add a check-in in one hour, returning true when the history changed and has to be saved
+func (c *CheckinHistory) Record(hour int64) bool {
+	switch {
+	case c.Since == 0:
+		c.Since = hour
+		c.Hour = hour
+		c.set(hour, true)
+		return true
+	case hour > c.Hour:
+		// Hours between the last check-in and this one had no check-ins
+		if hour-c.Hour >= CheckinHistoryHours {
+			c.Bits = [CheckinHistoryHours / 8]byte{}
+		} else {
+			for h := c.Hour + 1; h < hour; h++ {
+				c.set(h, false)
+			}
+		}
+		c.Hour = hour
+		c.set(hour, true)
+		return true
+	case c.Hour-hour >= CheckinHistoryHours || c.Seen(hour):
+		return false
+	}
+	if hour < c.Since {
+		c.Since = hour
+	}
+	c.set(hour, true)
+	return true
+}
+
+// Probability to get the fraction of the last 7 days in which a check-in happened within the window after any hour.
+// Nodes with less history than the window are expected to check in if they did within the last window.
+// It returns false when there is no history.
+func (c CheckinHistory) Probability(now int64, windowHours int64) (float64, bool) {
+	if c.Since == 0 {
+		return 0, false
+	}
+	if windowHours <= 0 {
+		windowHours = 1
+	}
+	start := now - CheckinHistoryHours + 1
+	if c.Since > start {
+		start = c.Since
+	}
+	span := now - start + 1
+	if span <= windowHours {
+		for h := now - windowHours + 1; h <= now; h++ {
+			if c.Seen(h) {
+				return 1, true
+			}
+		}
+		return 0, true
+	}
+	// Prefix sums of seen hours, so each window is checked at once
+	prefix := make([]int, span+1)
+	for i := int64(0); i < span; i++ {
+		prefix[i+1] = prefix[i]
+		if c.Seen(start + i) {
+			prefix[i+1]++
+		}
+	}
+	positions := span - windowHours + 1
+	covered := 0
+	for i := int64(0); i < positions; i++ {
+		if prefix[i+windowHours] > prefix[i] {
+			covered++
+		}
+	}
+	return float64(covered) / float64(positions), true
+}
+
+// RecordCheckin to update the check-in history of a node, returning the encoded history when it changed
+func RecordCheckin(node OsqueryNode, now time.Time) (string, bool) {
+	history := ParseCheckinHistory(node.CheckinHistory)
+	if !history.Record(CheckinHour(now)) {
+		return "", false
+	}
+	return history.Encode(), true
+}
+
+// EstimateCoverage to estimate how many of the target nodes are expected to check in within the window, based on
+// their check-in history of the last 7 days. Nodes without history do not count for the percentage.
+func EstimateCoverage(targets []OsqueryNode, now time.Time, window time.Duration) CoverageEstimate {
+	if window <= 0 {
+		window = DefaultCoverageWindow
+	}
+	hours := int64(math.Ceil(window.Hours()))
+	estimate := CoverageEstimate{
+		Window:      FormatWindow(hours),
+		WindowHours: hours,
+		HistoryDays: CheckinHistoryHours / 24,
+		Targets:     len(targets),
+		GeneratedAt: now.UTC().Format(time.RFC3339),
+	}
+	hour := CheckinHour(now)
+	for _, n := range targets {
+		p, ok := ParseCheckinHistory(n.CheckinHistory).Probability(hour, hours)
+		if !ok {
+			continue
+		}
+		estimate.WithHistory++
+		estimate.Expected += p
+	}
+	if estimate.WithHistory > 0 {
+		estimate.Percent = int(math.Round(100 * estimate.Expected / float64(estimate.WithHistory)))
+	}
+	estimate.Expected = math.Round(estimate.Expected*10) / 10
+	estimate.Summary = estimate.Describe()
+	return estimate
+}
+
+// Describe to explain the coverage estimate in one sentence
+func (e CoverageEstimate) Describe() string {
+	if e.Targets == 0 {
+		return "no nodes are targeted"
+	}
+	if e.WithHistory == 0 {
+		return fmt.Sprintf("none of the %d targets has check-in history yet", e.Targets)
+	}
+	summary := fmt.Sprintf("based on the last %d days of check-in patterns, ~%d%% of targets are expected to check in within %s", e.HistoryDays, e.Percent, e.Window)
+	if e.WithHistory < e.Targets {
+		summary += fmt.Sprintf(" (%d of %d targets have no history)", e.Targets-e.WithHistory, e.Targets)
+	}
+	return summary
+}
+
+// FormatWindow to display a window in hours, as days when they are whole days
+func FormatWindow(hours int64) string {
+	if hours >= 24 && hours%24 == 0 {
+		return fmt.Sprintf("%dd", hours/24)
+	}
+	return fmt.Sprintf("%dh", hours)
+}
+
+// CoverageTargets to select the nodes of a coverage estimate, by UUID, hostname or localname and by platform.
+// Without targets nor platforms all the nodes are selected.
+func CoverageTargets(all []OsqueryNode, targets, platforms []string) []OsqueryNode {
+	wanted := make(map[string]bool)
+	for _, t := range targets {
+		if t = strings.TrimSpace(t); t != "" {
+			wanted[strings.ToLower(t)] = true
+		}
+	}
+	wantedPlatforms := make(map[string]bool)
+	for _, p := range platforms {
+		if p = strings.TrimSpace(p); p != "" {
+			wantedPlatforms[strings.ToLower(p)] = true
+		}
+	}
+	if len(wanted) == 0 && len(wantedPlatforms) == 0 {
+		return all
+	}
+	var selected []OsqueryNode
+	for _, n := range all {
+		if wanted[strings.ToLower(n.UUID)] || wanted[strings.ToLower(n.Hostname)] || wanted[strings.ToLower(n.Localname)] || wantedPlatforms[strings.ToLower(n.Platform)] {
+			selected = append(selected, n)
+		}
+	}
+	return selected
+}
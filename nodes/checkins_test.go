@@ -0,0 +1,95 @@
+package nodes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckinHistoryRecord(t *testing.T) {
+	var c CheckinHistory
+	assert.True(t, c.Empty())
+	assert.True(t, c.Record(1000))
+	assert.False(t, c.Record(1000))
+	assert.True(t, c.Record(1003))
+	assert.True(t, c.Seen(1000))
+	assert.False(t, c.Seen(1001))
+	assert.True(t, c.Seen(1003))
+	assert.False(t, c.Seen(1004))
+	// Hours older than the history are ignored
+	assert.False(t, c.Record(1003-CheckinHistoryHours))
+	// Gaps longer than the history clear it
+	assert.True(t, c.Record(1003+CheckinHistoryHours))
+	assert.False(t, c.Seen(1003))
+	assert.True(t, c.Seen(1003+CheckinHistoryHours))
+}
+
+func TestCheckinHistoryEncode(t *testing.T) {
+	var c CheckinHistory
+	assert.Equal(t, "", c.Encode())
+	c.Record(5000)
+	c.Record(5010)
+	parsed := ParseCheckinHistory(c.Encode())
+	assert.Equal(t, c, parsed)
+	assert.True(t, ParseCheckinHistory("invalid").Empty())
+	assert.True(t, ParseCheckinHistory("1:2:zz").Empty())
+}
+
+func TestCheckinHistoryProbability(t *testing.T) {
+	var c CheckinHistory
+	_, ok := c.Probability(100, 24)
+	assert.False(t, ok)
+	// Check-ins every hour
+	for h := int64(1000); h < 1000+CheckinHistoryHours; h++ {
+		c.Record(h)
+	}
+	p, ok := c.Probability(1000+CheckinHistoryHours-1, 24)
+	assert.True(t, ok)
+	assert.Equal(t, 1.0, p)
+	// One check-in per day, always within a 24h window but not within 12h
+	var daily CheckinHistory
+	for h := int64(2400); h < 2400+CheckinHistoryHours; h += 24 {
+		daily.Record(h)
+	}
+	now := int64(2400 + CheckinHistoryHours - 1)
+	p, _ = daily.Probability(now, 24)
+	assert.Equal(t, 1.0, p)
+	p, _ = daily.Probability(now, 12)
+	assert.True(t, p > 0.4 && p < 0.6)
+	// Short history only checks the last window
+	var recent CheckinHistory
+	recent.Record(3000)
+	p, _ = recent.Probability(3005, 24)
+	assert.Equal(t, 1.0, p)
+}
+
+func TestEstimateCoverage(t *testing.T) {
+	now := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+	seen, _ := RecordCheckin(OsqueryNode{}, now)
+	targets := []OsqueryNode{{CheckinHistory: seen}, {CheckinHistory: seen}, {}}
+	e := EstimateCoverage(targets, now, 24*time.Hour)
+	assert.Equal(t, 3, e.Targets)
+	assert.Equal(t, 2, e.WithHistory)
+	assert.Equal(t, 100, e.Percent)
+	assert.Equal(t, "1d", e.Window)
+	assert.Equal(t, "based on the last 7 days of check-in patterns, ~100% of targets are expected to check in within 1d (1 of 3 targets have no history)", e.Summary)
+	assert.Equal(t, "no nodes are targeted", EstimateCoverage(nil, now, 0).Summary)
+}
+
+func TestFormatWindow(t *testing.T) {
+	assert.Equal(t, "36h", FormatWindow(36))
+	assert.Equal(t, "3d", FormatWindow(72))
+	assert.Equal(t, "1h", FormatWindow(1))
+}
+
+func TestCoverageTargets(t *testing.T) {
+	all := []OsqueryNode{
+		{UUID: "AAA", Hostname: "host1", Platform: "darwin"},
+		{UUID: "BBB", Hostname: "host2", Platform: "ubuntu"},
+		{UUID: "CCC", Localname: "local3", Platform: "windows"},
+	}
+	assert.Equal(t, 3, len(CoverageTargets(all, []string{""}, nil)))
+	assert.Equal(t, 2, len(CoverageTargets(all, []string{"aaa", "LOCAL3"}, nil)))
+	assert.Equal(t, 2, len(CoverageTargets(all, []string{"host2"}, []string{"darwin"})))
+}
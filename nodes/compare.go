@@ -0,0 +1,319 @@
+package nodes
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// CompareNode for the section with the platform, versions and hardware of nodes
+	CompareNode = "node"
+	// CompareHealth for the section with the check-ins, clock skew and data received of nodes
+	CompareHealth = "health"
+	// CompareEnroll for the section with the enrollment metadata of nodes
+	CompareEnroll = "enroll-metadata"
+	// CompareTags for the section with the tags of nodes
+	CompareTags = "tags"
+	// CompareAttributes for the section with the custom attributes of nodes
+	CompareAttributes = "attributes"
+	// CompareConfig for the section with the configuration served to nodes
+	CompareConfig = "config"
+	// CompareBaseline is used instead of the UUID to compare with the baseline of an environment
+	CompareBaseline = "baseline"
+	// DefaultComparePerPage is the default number of differences of each section in one page
+	DefaultComparePerPage = 50
+	// MaxComparePerPage is the maximum number of differences of each section in one page
+	MaxComparePerPage = 500
+	// BaselineSample is the maximum number of active nodes used to calculate the baseline of an environment
+	BaselineSample = 500
+)
+
+// CompareSections are all the sections of a comparison of nodes, in the order they are shown
+var CompareSections = []string{CompareNode, CompareHealth, CompareEnroll, CompareTags, CompareAttributes, CompareConfig}
+
+// NodeSnapshot to hold the values of a node flattened by section, to compare them with other nodes. A baseline
+// of an environment has the majority or median values of Nodes nodes.
+type NodeSnapshot struct {
+	Environment string                       `json:"environment"`
+	UUID        string                       `json:"uuid"`
+	Hostname    string                       `json:"hostname"`
+	Nodes       int                          `json:"nodes,omitempty"`
+	Sections    map[string]map[string]string `json:"-"`
+}
+
+// CompareEntry to hold one value that is different in two nodes, Only is a or b if the other node does not have it
+type CompareEntry struct {
+	Key  string `json:"key"`
+	A    string `json:"a"`
+	B    string `json:"b"`
+	Only string `json:"only,omitempty"`
+}
+
+// SectionDiff to hold one page of the differences of a section, with the total of differences and equal values
+type SectionDiff struct {
+	Section     string         `json:"section"`
+	Differences int            `json:"differences"`
+	Equal       int            `json:"equal"`
+	Page        int            `json:"page"`
+	PerPage     int            `json:"per_page"`
+	Entries     []CompareEntry `json:"entries"`
+}
+
+// NodeComparison to hold the differences of two nodes, or of a node and the baseline of an environment
+type NodeComparison struct {
+	A        NodeSnapshot  `json:"a"`
+	B        NodeSnapshot  `json:"b"`
+	Sections []SectionDiff `json:"sections"`
+}
+
+// Name to identify the node of a snapshot as environment/uuid
+func (s NodeSnapshot) Name() string {
+	return s.Environment + "/" + s.UUID
+}
+
+// ParseCompareTarget to get the environment and UUID of a node to compare, from environment/uuid. The UUID is
+// CompareBaseline to compare with the baseline of the environment.
+func ParseCompareTarget(value string) (string, string, error) {
+	parts := strings.SplitN(strings.TrimSpace(value), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid node %s, it must be environment/uuid", value)
+	}
+	if strings.EqualFold(parts[1], CompareBaseline) {
+		return parts[0], CompareBaseline, nil
+	}
+	return parts[0], parts[1], nil
+}
+
+// ValidCompareSection to check if a section can be compared
+func ValidCompareSection(section string) bool {
+	for _, s := range CompareSections {
+		if s == section {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper to get how many minutes ago something happened, empty if it never happened
+func minutesAgo(t, now time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return strconv.FormatInt(int64(now.Sub(t)/time.Minute), 10)
+}
+
+// Helper to add the values of a decoded JSON value to a section, with their path as key
+func flattenJSON(prefix string, value interface{}, into map[string]string) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			key := k
+			if prefix != "" {
+				key = prefix + "." + k
+			}
+			flattenJSON(key, child, into)
+		}
+	case []interface{}:
+		for i, child := range v {
+			flattenJSON(fmt.Sprintf("%s[%d]", prefix, i), child, into)
+		}
+	case string:
+		into[prefix] = v
+	default:
+		raw, _ := json.Marshal(v)
+		into[prefix] = string(raw)
+	}
+}
+
+// FlattenConfiguration to get the values of a serialized configuration by their path, like schedule.uptime.interval
+func FlattenConfiguration(configuration []byte) (map[string]string, error) {
+	values := make(map[string]string)
+	if len(configuration) == 0 {
+		return values, nil
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(configuration, &decoded); err != nil {
+		return values, fmt.Errorf("Unmarshal %v", err)
+	}
+	flattenJSON("", decoded, values)
+	return values, nil
+}
+
+// NewNodeSnapshot to prepare the values of a node to compare, with its tags, custom attributes, performance
+// profile and the configuration served to it
+func NewNodeSnapshot(node OsqueryNode, inactive int64, profile string, configuration []byte, tagNames []string, attrs []NodeAttribute, now time.Time) (NodeSnapshot, error) {
+	snapshot := NodeSnapshot{
+		Environment: node.Environment,
+		UUID:        node.UUID,
+		Hostname:    node.Hostname,
+		Sections:    make(map[string]map[string]string, len(CompareSections)),
+	}
+	snapshot.Sections[CompareNode] = map[string]string{
+		"platform":         node.Platform,
+		"platform_version": node.PlatformVersion,
+		"osquery_version":  node.OsqueryVersion,
+		"osquery_user":     node.OsqueryUser,
+		"daemon_hash":      node.DaemonHash,
+		"cpu":              node.CPU,
+		"memory":           node.Memory,
+		"hardware_model":   HardwareModel(node),
+		"enrolled":         node.CreatedAt.UTC().Format("2006-01-02"),
+	}
+	snapshot.Sections[CompareHealth] = map[string]string{
+		"active":                  strconv.FormatBool(IsActive(node, inactive)),
+		"last_status_minutes":     minutesAgo(node.LastStatus, now),
+		"last_result_minutes":     minutesAgo(node.LastResult, now),
+		"last_config_minutes":     minutesAgo(node.LastConfig, now),
+		"last_query_read_minutes": minutesAgo(node.LastQueryRead, now),
+		"clock_skew":              strconv.FormatInt(node.ClockSkew, 10),
+		"bytes_received":          strconv.Itoa(node.BytesReceived),
+		"config_hash":             node.ConfigHash,
+	}
+	snapshot.Sections[CompareEnroll] = EnrollMetadataValues(node)
+	tagged := make(map[string]string, len(tagNames))
+	for _, t := range tagNames {
+		tagged[t] = "tagged"
+	}
+	snapshot.Sections[CompareTags] = tagged
+	attributes := make(map[string]string, len(attrs))
+	for _, a := range attrs {
+		attributes[a.Name] = a.String()
+	}
+	snapshot.Sections[CompareAttributes] = attributes
+	config, err := FlattenConfiguration(configuration)
+	if err != nil {
+		return snapshot, err
+	}
+	config["profile"] = profile
+	snapshot.Sections[CompareConfig] = config
+	return snapshot, nil
+}
+
+// Helper to get the median of numeric values, false if any of them is not a number
+func medianValue(values []string) (string, bool) {
+	numbers := make([]float64, 0, len(values))
+	for _, v := range values {
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return "", false
+		}
+		numbers = append(numbers, n)
+	}
+	sort.Float64s(numbers)
+	median := numbers[len(numbers)/2]
+	if len(numbers)%2 == 0 {
+		median = (numbers[len(numbers)/2-1] + median) / 2
+	}
+	return strconv.FormatFloat(median, 'f', -1, 64), true
+}
+
+// Helper to get the most frequent value, ties go to the first value in order
+func majorityValue(values []string) string {
+	counts := make(map[string]int, len(values))
+	for _, v := range values {
+		counts[v]++
+	}
+	var majority string
+	best := 0
+	for v, c := range counts {
+		if c > best || (c == best && v < majority) {
+			majority, best = v, c
+		}
+	}
+	return majority
+}
+
+// BaselineSnapshot to get the baseline of the nodes of an environment, with the median of numeric values and the
+// most frequent value of the rest. Values that less than half of the nodes have are not in the baseline.
+func BaselineSnapshot(environment string, snapshots []NodeSnapshot) NodeSnapshot {
+	baseline := NodeSnapshot{
+		Environment: environment,
+		UUID:        CompareBaseline,
+		Hostname:    CompareBaseline,
+		Nodes:       len(snapshots),
+		Sections:    make(map[string]map[string]string, len(CompareSections)),
+	}
+	for _, section := range CompareSections {
+		values := make(map[string][]string)
+		for _, s := range snapshots {
+			for k, v := range s.Sections[section] {
+				values[k] = append(values[k], v)
+			}
+		}
+		baseline.Sections[section] = make(map[string]string)
+		for k, vs := range values {
+			if len(vs)*2 < len(snapshots) {
+				continue
+			}
+			if median, ok := medianValue(vs); ok {
+				baseline.Sections[section][k] = median
+			} else {
+				baseline.Sections[section][k] = majorityValue(vs)
+			}
+		}
+	}
+	return baseline
+}
+
+// CompareSnapshots to get the differences of two nodes in the requested sections, all of them if none is requested.
+// Each section has the page of its differences sorted by key, pages start at 1.
+func CompareSnapshots(a, b NodeSnapshot, sections []string, page, perPage int) NodeComparison {
+	if len(sections) == 0 {
+		sections = CompareSections
+	}
+	if page < 1 {
+		page = 1
+	}
+	if perPage < 1 {
+		perPage = DefaultComparePerPage
+	}
+	if perPage > MaxComparePerPage {
+		perPage = MaxComparePerPage
+	}
+	comparison := NodeComparison{A: a, B: b}
+	for _, section := range sections {
+		valuesA, valuesB := a.Sections[section], b.Sections[section]
+		keys := make([]string, 0, len(valuesA)+len(valuesB))
+		for k := range valuesA {
+			keys = append(keys, k)
+		}
+		for k := range valuesB {
+			if _, ok := valuesA[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		diff := SectionDiff{Section: section, Page: page, PerPage: perPage, Entries: []CompareEntry{}}
+		var entries []CompareEntry
+		for _, k := range keys {
+			va, okA := valuesA[k]
+			vb, okB := valuesB[k]
+			if okA && okB && va == vb {
+				diff.Equal++
+				continue
+			}
+			entry := CompareEntry{Key: k, A: va, B: vb}
+			if !okB {
+				entry.Only = "a"
+			}
+			if !okA {
+				entry.Only = "b"
+			}
+			entries = append(entries, entry)
+		}
+		diff.Differences = len(entries)
+		if start := (page - 1) * perPage; start < len(entries) {
+			end := start + perPage
+			if end > len(entries) {
+				end = len(entries)
+			}
+			diff.Entries = entries[start:end]
+		}
+		comparison.Sections = append(comparison.Sections, diff)
+	}
+	return comparison
+}
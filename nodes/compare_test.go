@@ -0,0 +1,108 @@
+package nodes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCompareTarget(t *testing.T) {
+	env, uuid, err := ParseCompareTarget("dev/abc-123")
+	assert.NoError(t, err)
+	assert.Equal(t, "dev", env)
+	assert.Equal(t, "abc-123", uuid)
+	_, uuid, err = ParseCompareTarget("dev/Baseline")
+	assert.NoError(t, err)
+	assert.Equal(t, CompareBaseline, uuid)
+	for _, v := range []string{"", "dev", "dev/", "/abc"} {
+		_, _, err = ParseCompareTarget(v)
+		assert.Error(t, err, v)
+	}
+}
+
+func TestFlattenConfiguration(t *testing.T) {
+	values, err := FlattenConfiguration([]byte(`{"options":{"config_refresh":300,"verbose":false},"schedule":{"uptime":{"query":"SELECT 1;"}},"file_paths":{"etc":["/etc/%%"]}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"options.config_refresh": "300",
+		"options.verbose":        "false",
+		"schedule.uptime.query":  "SELECT 1;",
+		"file_paths.etc[0]":      "/etc/%%",
+	}, values)
+	_, err = FlattenConfiguration([]byte("{"))
+	assert.Error(t, err)
+}
+
+func TestNewNodeSnapshot(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	node := OsqueryNode{UUID: "AAA", Environment: "dev", Platform: "ubuntu", OsqueryVersion: "5.9.1", LastStatus: now.Add(-90 * time.Minute), EnrollMetadata: `{"team":"infra"}`}
+	attrs := []NodeAttribute{{Name: "cmdb.owner", Type: AttributeString, StringValue: "ops"}}
+	s, err := NewNodeSnapshot(node, 72, "server", []byte(`{"options":{"config_refresh":300}}`), []string{"prod"}, attrs, now)
+	assert.NoError(t, err)
+	assert.Equal(t, "dev/AAA", s.Name())
+	assert.Equal(t, "5.9.1", s.Sections[CompareNode]["osquery_version"])
+	assert.Equal(t, "90", s.Sections[CompareHealth]["last_status_minutes"])
+	assert.Equal(t, "", s.Sections[CompareHealth]["last_result_minutes"])
+	assert.Equal(t, "infra", s.Sections[CompareEnroll]["team"])
+	assert.Equal(t, "tagged", s.Sections[CompareTags]["prod"])
+	assert.Equal(t, "ops", s.Sections[CompareAttributes]["cmdb.owner"])
+	assert.Equal(t, "server", s.Sections[CompareConfig]["profile"])
+	assert.Equal(t, "300", s.Sections[CompareConfig]["options.config_refresh"])
+}
+
+func TestCompareSnapshots(t *testing.T) {
+	a := NodeSnapshot{Sections: map[string]map[string]string{
+		CompareNode: {"platform": "ubuntu", "osquery_version": "5.9.1"},
+		CompareTags: {"prod": "tagged", "web": "tagged"},
+	}}
+	b := NodeSnapshot{Sections: map[string]map[string]string{
+		CompareNode: {"platform": "ubuntu", "osquery_version": "5.8.2"},
+		CompareTags: {"prod": "tagged", "db": "tagged"},
+	}}
+	c := CompareSnapshots(a, b, nil, 1, 0)
+	assert.Len(t, c.Sections, len(CompareSections))
+	assert.Equal(t, CompareNode, c.Sections[0].Section)
+	assert.Equal(t, 1, c.Sections[0].Differences)
+	assert.Equal(t, 1, c.Sections[0].Equal)
+	assert.Equal(t, []CompareEntry{{Key: "osquery_version", A: "5.9.1", B: "5.8.2"}}, c.Sections[0].Entries)
+	assert.Equal(t, DefaultComparePerPage, c.Sections[0].PerPage)
+	// Sections requested, with values only in one of the nodes
+	c = CompareSnapshots(a, b, []string{CompareTags}, 1, 1)
+	assert.Len(t, c.Sections, 1)
+	assert.Equal(t, 2, c.Sections[0].Differences)
+	assert.Equal(t, []CompareEntry{{Key: "db", B: "tagged", Only: "b"}}, c.Sections[0].Entries)
+	c = CompareSnapshots(a, b, []string{CompareTags}, 2, 1)
+	assert.Equal(t, []CompareEntry{{Key: "web", A: "tagged", Only: "a"}}, c.Sections[0].Entries)
+	// Pages after the last one are empty
+	c = CompareSnapshots(a, b, []string{CompareTags}, 3, 1)
+	assert.Empty(t, c.Sections[0].Entries)
+	assert.Equal(t, 2, c.Sections[0].Differences)
+}
+
+func TestBaselineSnapshot(t *testing.T) {
+	snapshot := func(version, skew string, tags ...string) NodeSnapshot {
+		s := NodeSnapshot{Sections: map[string]map[string]string{
+			CompareNode:   {"osquery_version": version},
+			CompareHealth: {"clock_skew": skew},
+			CompareTags:   {},
+		}}
+		for _, t := range tags {
+			s.Sections[CompareTags][t] = "tagged"
+		}
+		return s
+	}
+	baseline := BaselineSnapshot("dev", []NodeSnapshot{
+		snapshot("5.9.1", "1", "prod"),
+		snapshot("5.9.1", "3", "prod"),
+		snapshot("5.8.2", "100", "web"),
+		snapshot("5.9.1", "2", "prod"),
+	})
+	assert.Equal(t, "dev/baseline", baseline.Name())
+	assert.Equal(t, 4, baseline.Nodes)
+	assert.Equal(t, "5.9.1", baseline.Sections[CompareNode]["osquery_version"])
+	assert.Equal(t, "2.5", baseline.Sections[CompareHealth]["clock_skew"])
+	assert.Equal(t, map[string]string{"prod": "tagged"}, baseline.Sections[CompareTags])
+	// Ties go to the first value in order
+	assert.Equal(t, "a", majorityValue([]string{"b", "a", "b", "a"}))
+}
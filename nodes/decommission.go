@@ -0,0 +1,191 @@
+package nodes
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// DecommissionPending for nodes told to remove osquery, still within the grace period
+	DecommissionPending string = "pending"
+	// DecommissionSilent for nodes that stopped contacting for the grace period, the node is archived
+	DecommissionSilent string = "silent"
+	// DecommissionContacting for nodes still contacting after the grace period
+	DecommissionContacting string = "contacting"
+	// DefaultDecommissionGrace is the default time a decommissioned node has to stop contacting
+	DefaultDecommissionGrace = 7 * 24 * time.Hour
+	// Trigger for archived nodes that were decommissioned
+	decommissionTrigger = "decommission"
+	// How long the decommissioned nodes are cached before reading them again from the DB
+	decommissionCacheTTL = 60 * time.Second
+)
+
+// NodeDecommission to keep track of nodes being decommissioned and if they stopped contacting
+type NodeDecommission struct {
+	gorm.Model
+	UUID          string `gorm:"index"`
+	NodeID        uint
+	EnvironmentID uint `gorm:"index"`
+	Hostname      string
+	Localname     string
+	Platform      string
+	Requester     string
+	State         string
+	LastContact   time.Time
+	Contacts      int
+	ArchivedAt    time.Time
+}
+
+// decommissionCache to keep the UUIDs of nodes being decommissioned in memory between DB reads
+type decommissionCache struct {
+	mux     sync.Mutex
+	uuids   map[string]bool
+	expires time.Time
+}
+
+// DecommissionState to get the state of a decommission at a given time.
+// Nodes are silent when they did not contact for the whole grace period, and
+// still contacting when the grace period is over but the last contact is within it.
+func DecommissionState(d NodeDecommission, grace time.Duration, now time.Time) string {
+	if d.State == DecommissionSilent {
+		return DecommissionSilent
+	}
+	silentSince := d.CreatedAt
+	if d.LastContact.After(silentSince) {
+		silentSince = d.LastContact
+	}
+	if now.Sub(silentSince) >= grace {
+		return DecommissionSilent
+	}
+	if now.Sub(d.CreatedAt) >= grace {
+		return DecommissionContacting
+	}
+	return DecommissionPending
+}
+
+// Helper to get the grace period for decommissioned nodes, default if not set
+func (n *NodeManager) decommissionGrace() time.Duration {
+	if n.DecommissionGrace > 0 {
+		return n.DecommissionGrace
+	}
+	return DefaultDecommissionGrace
+}
+
+// Decommission to mark a node to remove osquery, it is archived once it stops contacting
+func (n *NodeManager) Decommission(uuid, requester string) (NodeDecommission, error) {
+	node, err := n.GetByUUID(uuid)
+	if err != nil {
+		return NodeDecommission{}, fmt.Errorf("getNodeByUUID %v", err)
+	}
+	if active, err := n.GetDecommission(node.UUID); err == nil && active.State != DecommissionSilent {
+		return active, nil
+	}
+	d := NodeDecommission{
+		UUID:          node.UUID,
+		NodeID:        node.ID,
+		EnvironmentID: node.EnvironmentID,
+		Hostname:      node.Hostname,
+		Localname:     node.Localname,
+		Platform:      node.Platform,
+		Requester:     requester,
+		State:         DecommissionPending,
+	}
+	if err := n.DB.Create(&d).Error; err != nil {
+		return d, fmt.Errorf("Create %v", err)
+	}
+	n.invalidateDecommissions()
+	return d, nil
+}
+
+// GetDecommission to get the latest decommission of a node by UUID
+func (n *NodeManager) GetDecommission(uuid string) (NodeDecommission, error) {
+	var d NodeDecommission
+	if err := n.read().Where("uuid = ?", strings.ToUpper(uuid)).Order("created_at desc").First(&d).Error; err != nil {
+		return d, err
+	}
+	return d, nil
+}
+
+// GetDecommissions to get decommissions by state, all states if empty, for one environment or all with envid 0
+func (n *NodeManager) GetDecommissions(envid uint, state string) ([]NodeDecommission, error) {
+	var ds []NodeDecommission
+	query := n.read()
+	if envid != 0 {
+		query = query.Where("environment_id = ?", envid)
+	}
+	if state != "" {
+		query = query.Where("state = ?", state)
+	}
+	if err := query.Order("created_at desc").Find(&ds).Error; err != nil {
+		return ds, err
+	}
+	return ds, nil
+}
+
+// Helper to force the next read of decommissioned nodes from the DB
+func (n *NodeManager) invalidateDecommissions() {
+	n.decommissions.mux.Lock()
+	n.decommissions.expires = time.Time{}
+	n.decommissions.mux.Unlock()
+}
+
+// IsDecommissioned to check if a node by UUID is being decommissioned and must be rejected
+func (n *NodeManager) IsDecommissioned(uuid string) (bool, error) {
+	n.decommissions.mux.Lock()
+	defer n.decommissions.mux.Unlock()
+	if time.Now().After(n.decommissions.expires) {
+		var uuids []string
+		if err := n.read().Model(&NodeDecommission{}).Where("state <> ?", DecommissionSilent).Pluck("uuid", &uuids).Error; err != nil {
+			return false, err
+		}
+		n.decommissions.uuids = make(map[string]bool, len(uuids))
+		for _, u := range uuids {
+			n.decommissions.uuids[u] = true
+		}
+		n.decommissions.expires = time.Now().Add(decommissionCacheTTL)
+	}
+	return n.decommissions.uuids[strings.ToUpper(uuid)], nil
+}
+
+// RecordDecommissionContact to record that a decommissioned node is still contacting
+func (n *NodeManager) RecordDecommissionContact(uuid string) error {
+	err := n.DB.Model(&NodeDecommission{}).Where("uuid = ? AND state <> ?", strings.ToUpper(uuid), DecommissionSilent).Updates(map[string]interface{}{
+		"last_contact": time.Now(),
+		"contacts":     gorm.Expr("contacts + ?", 1),
+	}).Error
+	if err != nil {
+		return fmt.Errorf("Updates %v", err)
+	}
+	return nil
+}
+
+// CheckDecommissions to update the state of decommissioned nodes and archive the ones that went silent
+func (n *NodeManager) CheckDecommissions() error {
+	var ds []NodeDecommission
+	if err := n.DB.Where("state <> ?", DecommissionSilent).Find(&ds).Error; err != nil {
+		return fmt.Errorf("Find %v", err)
+	}
+	now := time.Now()
+	for _, d := range ds {
+		state := DecommissionState(d, n.decommissionGrace(), now)
+		if state == d.State {
+			continue
+		}
+		updates := map[string]interface{}{"state": state}
+		if state == DecommissionSilent {
+			if err := n.archiveDelete(d.UUID, decommissionTrigger, d.Requester); err != nil && err != gorm.ErrRecordNotFound {
+				return fmt.Errorf("archiveDelete %s %v", d.UUID, err)
+			}
+			updates["archived_at"] = now
+		}
+		if err := n.DB.Model(&d).Updates(updates).Error; err != nil {
+			return fmt.Errorf("Updates %v", err)
+		}
+	}
+	n.invalidateDecommissions()
+	return nil
+}
@@ -0,0 +1,31 @@
+package nodes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestDecommissionState(t *testing.T) {
+	now := time.Now()
+	grace := 24 * time.Hour
+	d := NodeDecommission{Model: gorm.Model{CreatedAt: now.Add(-2 * time.Hour)}, State: DecommissionPending}
+	assert.Equal(t, DecommissionPending, DecommissionState(d, grace, now))
+	// Never contacted after the grace period
+	d.CreatedAt = now.Add(-25 * time.Hour)
+	assert.Equal(t, DecommissionSilent, DecommissionState(d, grace, now))
+	// Contacted recently, after the grace period
+	d.LastContact = now.Add(-time.Hour)
+	assert.Equal(t, DecommissionContacting, DecommissionState(d, grace, now))
+	// Still contacting nodes go silent once they stop for the grace period
+	d.CreatedAt = now.Add(-72 * time.Hour)
+	d.LastContact = now.Add(-30 * time.Hour)
+	d.State = DecommissionContacting
+	assert.Equal(t, DecommissionSilent, DecommissionState(d, grace, now))
+	// Silent is final
+	d.State = DecommissionSilent
+	d.LastContact = now
+	assert.Equal(t, DecommissionSilent, DecommissionState(d, grace, now))
+}
@@ -0,0 +1,409 @@
+package nodes
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmpsec/osctrl/backend"
+	"gorm.io/gorm"
+)
+
+const (
+	// EnrollLoopWindow is the sliding window to count the enrolls of a host
+	EnrollLoopWindow = time.Hour
+	// DefaultEnrollLoopThreshold is the default enrolls within the window to flag a host as looping
+	DefaultEnrollLoopThreshold int = 5
+	// DefaultEnrollLoopQuiet is the default time without enrolls to clear the flag of a looping host
+	DefaultEnrollLoopQuiet = 2 * time.Hour
+	// EnrollLoopBaseBackoff is the backoff after the first enroll over the threshold, doubled with each one after
+	EnrollLoopBaseBackoff = time.Minute
+	// EnrollLoopMaxBackoff is the longest backoff, shorter than the quiet period so looping hosts stay flagged
+	EnrollLoopMaxBackoff = 30 * time.Minute
+	// DefaultEnrollLoopInterval is the default interval to clear quiet loops and delete old enroll events
+	DefaultEnrollLoopInterval = 15 * time.Minute
+	// EnrollEventRetention is the time to keep enroll events, to show the successive enrolls of loops
+	EnrollEventRetention = 24 * time.Hour
+	// EnrollLoopRetention is the time to keep cleared loops in the report
+	EnrollLoopRetention = 7 * 24 * time.Hour
+	// Enroll events compared to find the causes of a loop
+	enrollHintEvents = 20
+)
+
+// EnrollEvent to keep each enroll of a host, with what is needed to compare successive enrolls
+type EnrollEvent struct {
+	ID             uint      `gorm:"primaryKey" json:"-"`
+	UUID           string    `gorm:"index" json:"uuid"`
+	EnvironmentID  uint      `gorm:"index" json:"-"`
+	Secret         string    `json:"secret"`
+	OsqueryVersion string    `json:"osquery_version"`
+	ConfigHash     string    `json:"config_hash"`
+	InstanceID     string    `json:"instance_id"`
+	StartTime      int64     `json:"start_time"`
+	IPAddress      string    `json:"ip_address"`
+	CreatedAt      time.Time `gorm:"index" json:"timestamp"`
+}
+
+// EnrollLoop to keep the hosts of an environment that enroll again and again
+type EnrollLoop struct {
+	gorm.Model
+	UUID          string `gorm:"index"`
+	EnvironmentID uint   `gorm:"index"`
+	Hostname      string
+	Looping       bool
+	Enrolls       int
+	Since         time.Time
+	LastEnroll    time.Time
+	BlockedUntil  time.Time
+	Blocked       int
+	Hints         string
+}
+
+// EnrollLoopConfig to detect and rate limit enroll loops in one environment
+type EnrollLoopConfig struct {
+	// Enrolls within the window to flag a host as looping
+	Threshold int
+	// Time without enrolls to clear the flag
+	Quiet time.Duration
+	// Looping hosts get a backoff response instead of a new node key
+	Backoff bool
+}
+
+// EnrollLoopReport to return the enroll loops of an environment, with its configuration
+type EnrollLoopReport struct {
+	Threshold    int               `json:"threshold"`
+	QuietMinutes int               `json:"quiet_minutes"`
+	Backoff      bool              `json:"backoff"`
+	Looping      int               `json:"looping"`
+	Loops        []EnrollLoopEntry `json:"loops"`
+}
+
+// EnrollLoopEntry to return one host of the enroll loop report, with its latest enrolls to compare them
+type EnrollLoopEntry struct {
+	UUID         string        `json:"uuid"`
+	Hostname     string        `json:"hostname"`
+	Looping      bool          `json:"looping"`
+	Enrolls      int           `json:"enrolls"`
+	Since        time.Time     `json:"since"`
+	LastEnroll   time.Time     `json:"last_enroll"`
+	BlockedUntil time.Time     `json:"blocked_until"`
+	Blocked      int           `json:"blocked"`
+	Hints        []string      `json:"hints"`
+	Events       []EnrollEvent `json:"events"`
+}
+
+// NewEnrollLoopConfig to get the enroll loop configuration of an environment, with defaults for values not set
+func NewEnrollLoopConfig(threshold, quietMinutes int, backoff bool) EnrollLoopConfig {
+	cfg := EnrollLoopConfig{Threshold: threshold, Quiet: time.Duration(quietMinutes) * time.Minute, Backoff: backoff}
+	if cfg.Threshold <= 0 {
+		cfg.Threshold = DefaultEnrollLoopThreshold
+	}
+	if cfg.Quiet <= 0 {
+		cfg.Quiet = DefaultEnrollLoopQuiet
+	}
+	return cfg
+}
+
+// SecretFingerprint to identify the secret used to enroll without keeping it
+func SecretFingerprint(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// Active to check if a loop is still flagged, it is cleared after the quiet period without enrolls
+func (l EnrollLoop) Active(now time.Time, quiet time.Duration) bool {
+	return l.Looping && now.Sub(l.LastEnroll) < quiet
+}
+
+// HintList to get the hints of a loop
+func (l EnrollLoop) HintList() []string {
+	if l.Hints == "" {
+		return []string{}
+	}
+	return strings.Split(l.Hints, "\n")
+}
+
+// EnrollLoopBackoff to get how long a looping host waits for its next enroll, with the enrolls over the threshold
+func EnrollLoopBackoff(over int) time.Duration {
+	if over <= 0 {
+		return 0
+	}
+	backoff := EnrollLoopBaseBackoff
+	for i := 1; i < over && backoff < EnrollLoopMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > EnrollLoopMaxBackoff {
+		backoff = EnrollLoopMaxBackoff
+	}
+	return backoff
+}
+
+// EnrollLoopHints to compare successive enrolls of a host, sorted by time, and hint at the cause of the loop
+func EnrollLoopHints(events []EnrollEvent) []string {
+	hints := []string{}
+	if len(events) < 2 {
+		return hints
+	}
+	distinct := func(value func(e EnrollEvent) string) int {
+		seen := make(map[string]bool)
+		for _, e := range events {
+			seen[value(e)] = true
+		}
+		return len(seen)
+	}
+	changed := false
+	if n := distinct(func(e EnrollEvent) string { return e.Secret }); n > 1 {
+		changed = true
+		hints = append(hints, fmt.Sprintf("%d different enroll secrets are used: more than one secret file or deployment targets this host", n))
+	}
+	if n := distinct(func(e EnrollEvent) string { return e.OsqueryVersion }); n > 1 {
+		changed = true
+		hints = append(hints, fmt.Sprintf("%d osquery versions enroll: packages of different versions are being installed", n))
+	}
+	if n := distinct(func(e EnrollEvent) string { return e.ConfigHash }); n > 1 {
+		changed = true
+		hints = append(hints, fmt.Sprintf("%d configuration hashes: the host gets a different configuration between enrolls", n))
+	}
+	if n := distinct(func(e EnrollEvent) string { return e.IPAddress }); n > 1 {
+		changed = true
+		hints = append(hints, fmt.Sprintf("%d IP addresses: more than one host may share this host identifier, like cloned machines", n))
+	}
+	if n := distinct(func(e EnrollEvent) string { return e.InstanceID }); n > 1 {
+		changed = true
+		hints = append(hints, fmt.Sprintf("%d osquery instance IDs: the osquery database is not kept, so the node key is lost, check --database_path", n))
+	}
+	if n := distinct(func(e EnrollEvent) string { return strconv.FormatInt(e.StartTime, 10) }); n > 1 && !changed {
+		changed = true
+		hints = append(hints, "osquery restarts before each enroll: check the watchdog and the osquery service logs")
+	}
+	last := events[len(events)-1]
+	if last.StartTime > 0 {
+		if skew := ClockSkew(time.Unix(last.StartTime, 0), last.CreatedAt); skew > DefaultClockSkew {
+			hints = append(hints, fmt.Sprintf("osquery started %ds in the future: the clock of the host is ahead of the server", skew))
+		}
+	}
+	if !changed {
+		hints = append(hints, "the same osquery process enrolls again: its node key is rejected, check the clock of the host and the TLS endpoints")
+	}
+	return hints
+}
+
+// RecordEnroll to record one enroll of a host and update its loop. Hosts with more enrolls than the threshold
+// within the window are flagged as looping and, if backoff is enabled, blocked until their next enroll is allowed.
+func (n *NodeManager) RecordEnroll(event EnrollEvent, hostname string, cfg EnrollLoopConfig) (EnrollLoop, error) {
+	var loop EnrollLoop
+	event.UUID = strings.ToUpper(event.UUID)
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+	now := event.CreatedAt
+	err := n.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&event).Error; err != nil {
+			return fmt.Errorf("Create EnrollEvent %v", err)
+		}
+		var events []EnrollEvent
+		if err := tx.Where("uuid = ? AND environment_id = ? AND created_at > ?", event.UUID, event.EnvironmentID, now.Add(-EnrollLoopWindow)).Order("created_at desc, id desc").Limit(enrollHintEvents).Find(&events).Error; err != nil {
+			return fmt.Errorf("Find %v", err)
+		}
+		var count int64
+		if err := tx.Model(&EnrollEvent{}).Where("uuid = ? AND environment_id = ? AND created_at > ?", event.UUID, event.EnvironmentID, now.Add(-EnrollLoopWindow)).Count(&count).Error; err != nil {
+			return fmt.Errorf("Count %v", err)
+		}
+		res := tx.Where("uuid = ? AND environment_id = ?", event.UUID, event.EnvironmentID).Limit(1).Find(&loop)
+		if res.Error != nil {
+			return fmt.Errorf("Find %v", res.Error)
+		}
+		if !loop.Active(now, cfg.Quiet) && int(count) <= cfg.Threshold {
+			// Not looping, a previous loop is cleared
+			if res.RowsAffected == 0 || !loop.Looping {
+				return nil
+			}
+			loop.Looping = false
+			loop.BlockedUntil = time.Time{}
+			return tx.Save(&loop).Error
+		}
+		if !loop.Active(now, cfg.Quiet) {
+			loop.Since = now
+			loop.Blocked = 0
+		}
+		// Oldest first to compare successive enrolls
+		for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+			events[i], events[j] = events[j], events[i]
+		}
+		loop.UUID = event.UUID
+		loop.EnvironmentID = event.EnvironmentID
+		loop.Hostname = hostname
+		loop.Looping = true
+		loop.Enrolls = int(count)
+		loop.LastEnroll = now
+		loop.Hints = strings.Join(EnrollLoopHints(events), "\n")
+		loop.BlockedUntil = time.Time{}
+		if cfg.Backoff {
+			loop.BlockedUntil = now.Add(EnrollLoopBackoff(int(count) - cfg.Threshold))
+		}
+		if err := tx.Save(&loop).Error; err != nil {
+			return fmt.Errorf("Save EnrollLoop %v", err)
+		}
+		return nil
+	})
+	return loop, err
+}
+
+// EnrollBlocked to check if a looping host must wait before enrolling again, counting the enroll it was denied
+func (n *NodeManager) EnrollBlocked(uuid string, envid uint, now time.Time) (time.Duration, error) {
+	var loop EnrollLoop
+	res := n.DB.Where("uuid = ? AND environment_id = ? AND looping = ? AND blocked_until > ?", strings.ToUpper(uuid), envid, true, now).Limit(1).Find(&loop)
+	if res.Error != nil {
+		return 0, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return 0, nil
+	}
+	if err := n.DB.Model(&loop).Update("blocked", gorm.Expr("blocked + 1")).Error; err != nil {
+		return 0, fmt.Errorf("Update %v", err)
+	}
+	return loop.BlockedUntil.Sub(now), nil
+}
+
+// GetEnrollLoops to get the loops of an environment, the ones still flagged first
+func (n *NodeManager) GetEnrollLoops(envid uint) ([]EnrollLoop, error) {
+	var loops []EnrollLoop
+	if err := n.read().Where("environment_id = ?", envid).Order("looping desc, last_enroll desc").Find(&loops).Error; err != nil {
+		return loops, err
+	}
+	return loops, nil
+}
+
+// LoopingUUIDs to get the UUIDs of the hosts of an environment flagged as looping
+func (n *NodeManager) LoopingUUIDs(envid uint, now time.Time, quiet time.Duration) (map[string]bool, error) {
+	var uuids []string
+	if err := n.read().Model(&EnrollLoop{}).Where("environment_id = ? AND looping = ? AND last_enroll > ?", envid, true, now.Add(-quiet)).Pluck("uuid", &uuids).Error; err != nil {
+		return nil, err
+	}
+	res := make(map[string]bool, len(uuids))
+	for _, u := range uuids {
+		res[u] = true
+	}
+	return res, nil
+}
+
+// GetEnrollEvents to get the latest enroll events of a host in an environment, sorted by time
+func (n *NodeManager) GetEnrollEvents(uuid string, envid uint, limit int) ([]EnrollEvent, error) {
+	var events []EnrollEvent
+	if err := n.read().Where("uuid = ? AND environment_id = ?", strings.ToUpper(uuid), envid).Order("created_at desc, id desc").Limit(limit).Find(&events).Error; err != nil {
+		return events, err
+	}
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+	return events, nil
+}
+
+// EnrollLoopReport to get the report of the enroll loops of an environment, with the latest enrolls of the hosts still looping
+func (n *NodeManager) EnrollLoopReport(envid uint, cfg EnrollLoopConfig, now time.Time) (EnrollLoopReport, error) {
+	report := EnrollLoopReport{
+		Threshold:    cfg.Threshold,
+		QuietMinutes: int(cfg.Quiet / time.Minute),
+		Backoff:      cfg.Backoff,
+		Loops:        []EnrollLoopEntry{},
+	}
+	loops, err := n.GetEnrollLoops(envid)
+	if err != nil {
+		return report, fmt.Errorf("GetEnrollLoops %v", err)
+	}
+	for _, l := range loops {
+		entry := EnrollLoopEntry{
+			UUID:         l.UUID,
+			Hostname:     l.Hostname,
+			Looping:      l.Active(now, cfg.Quiet),
+			Enrolls:      l.Enrolls,
+			Since:        l.Since,
+			LastEnroll:   l.LastEnroll,
+			BlockedUntil: l.BlockedUntil,
+			Blocked:      l.Blocked,
+			Hints:        l.HintList(),
+			Events:       []EnrollEvent{},
+		}
+		if entry.Looping {
+			report.Looping++
+			if entry.Events, err = n.GetEnrollEvents(l.UUID, envid, enrollHintEvents); err != nil {
+				return report, fmt.Errorf("GetEnrollEvents %v", err)
+			}
+		}
+		report.Loops = append(report.Loops, entry)
+	}
+	return report, nil
+}
+
+// ClearEnrollLoops to clear the flag of loops without enrolls for the quiet period of their environment,
+// environments not in the map use the default, and delete old enroll events and cleared loops
+func (n *NodeManager) ClearEnrollLoops(now time.Time, quiet map[uint]time.Duration) error {
+	var loops []EnrollLoop
+	if err := n.DB.Where("looping = ?", true).Find(&loops).Error; err != nil {
+		return fmt.Errorf("Find %v", err)
+	}
+	for _, l := range loops {
+		q, ok := quiet[l.EnvironmentID]
+		if !ok || q <= 0 {
+			q = DefaultEnrollLoopQuiet
+		}
+		if l.Active(now, q) {
+			continue
+		}
+		if err := n.DB.Model(&l).Updates(map[string]interface{}{"looping": false, "blocked_until": time.Time{}}).Error; err != nil {
+			return fmt.Errorf("Updates %v", err)
+		}
+	}
+	if err := n.DB.Where("created_at < ?", now.Add(-EnrollEventRetention)).Delete(&EnrollEvent{}).Error; err != nil {
+		return fmt.Errorf("Delete %v", err)
+	}
+	if err := n.DB.Unscoped().Where("looping = ? AND last_enroll < ?", false, now.Add(-EnrollLoopRetention)).Delete(&EnrollLoop{}).Error; err != nil {
+		return fmt.Errorf("Delete %v", err)
+	}
+	return nil
+}
+
+// StartEnrollLoopCleanup to clear quiet loops periodically, only by the instance holding the leader lock
+func (n *NodeManager) StartEnrollLoopCleanup(ctx context.Context, leader *backend.LeaderLock, interval time.Duration, quiet func() (map[uint]time.Duration, error)) {
+	if interval <= 0 {
+		interval = DefaultEnrollLoopInterval
+	}
+	run := func() {
+		if leader != nil {
+			isLeader, err := leader.Acquire()
+			if err != nil {
+				log.Printf("error acquiring leader lock %v", err)
+				return
+			}
+			if !isLeader {
+				return
+			}
+		}
+		q, err := quiet()
+		if err != nil {
+			log.Printf("error getting quiet periods of enroll loops %v", err)
+			return
+		}
+		if err := n.ClearEnrollLoops(time.Now(), q); err != nil {
+			log.Printf("error clearing enroll loops %v", err)
+		}
+	}
+	go func() {
+		run()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				run()
+			}
+		}
+	}()
+}
@@ -0,0 +1,176 @@
+package nodes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// Enrolls of an agent that loses its database, every few minutes from the same host
+func loopingAgent(start time.Time, enrolls int) []EnrollEvent {
+	var events []EnrollEvent
+	for i := 0; i < enrolls; i++ {
+		events = append(events, EnrollEvent{
+			UUID:           "ABC",
+			Secret:         SecretFingerprint("secret"),
+			OsqueryVersion: "5.2.2",
+			ConfigHash:     "hash",
+			InstanceID:     string(rune('a' + i)),
+			StartTime:      start.Add(time.Duration(i) * 5 * time.Minute).Unix(),
+			IPAddress:      "10.0.0.1",
+			CreatedAt:      start.Add(time.Duration(i) * 5 * time.Minute),
+		})
+	}
+	return events
+}
+
+func TestNewEnrollLoopConfig(t *testing.T) {
+	cfg := NewEnrollLoopConfig(0, 0, false)
+	assert.Equal(t, DefaultEnrollLoopThreshold, cfg.Threshold)
+	assert.Equal(t, DefaultEnrollLoopQuiet, cfg.Quiet)
+	cfg = NewEnrollLoopConfig(10, 30, true)
+	assert.Equal(t, 10, cfg.Threshold)
+	assert.Equal(t, 30*time.Minute, cfg.Quiet)
+	assert.True(t, cfg.Backoff)
+}
+
+func TestEnrollLoopBackoff(t *testing.T) {
+	assert.Equal(t, time.Duration(0), EnrollLoopBackoff(0))
+	assert.Equal(t, time.Minute, EnrollLoopBackoff(1))
+	assert.Equal(t, 2*time.Minute, EnrollLoopBackoff(2))
+	assert.Equal(t, 16*time.Minute, EnrollLoopBackoff(5))
+	assert.Equal(t, EnrollLoopMaxBackoff, EnrollLoopBackoff(6))
+	assert.Equal(t, EnrollLoopMaxBackoff, EnrollLoopBackoff(100))
+	// Looping hosts stay flagged while they wait
+	assert.Less(t, int64(EnrollLoopMaxBackoff), int64(DefaultEnrollLoopQuiet))
+}
+
+func TestEnrollLoopHints(t *testing.T) {
+	start := time.Date(2022, 3, 8, 14, 0, 0, 0, time.UTC)
+	assert.Empty(t, EnrollLoopHints(loopingAgent(start, 1)))
+	hints := EnrollLoopHints(loopingAgent(start, 6))
+	assert.Len(t, hints, 1)
+	assert.Contains(t, hints[0], "6 osquery instance IDs")
+	// Secrets and versions that change between enrolls
+	events := loopingAgent(start, 3)
+	events[1].Secret = SecretFingerprint("other")
+	events[2].OsqueryVersion = "5.3.0"
+	hints = EnrollLoopHints(events)
+	assert.Contains(t, hints[0], "2 different enroll secrets")
+	assert.Contains(t, hints[1], "2 osquery versions")
+	// The same process enrolling again
+	events = loopingAgent(start, 3)
+	for i := range events {
+		events[i].InstanceID = "same"
+		events[i].StartTime = start.Unix()
+	}
+	hints = EnrollLoopHints(events)
+	assert.Len(t, hints, 1)
+	assert.Contains(t, hints[0], "node key is rejected")
+	// Clock of the host ahead of the server
+	events[2].StartTime = events[2].CreatedAt.Add(time.Hour).Unix()
+	hints = EnrollLoopHints(events)
+	assert.Contains(t, hints[0], "osquery restarts")
+	assert.Contains(t, hints[1], "3600s in the future")
+}
+
+func TestEnrollLoopActive(t *testing.T) {
+	now := time.Date(2022, 3, 8, 14, 0, 0, 0, time.UTC)
+	loop := EnrollLoop{Looping: true, LastEnroll: now.Add(-time.Hour)}
+	assert.True(t, loop.Active(now, DefaultEnrollLoopQuiet))
+	assert.False(t, loop.Active(now, 30*time.Minute))
+	loop.Looping = false
+	assert.False(t, loop.Active(now, DefaultEnrollLoopQuiet))
+	assert.Equal(t, []string{}, loop.HintList())
+	loop.Hints = "one\ntwo"
+	assert.Equal(t, []string{"one", "two"}, loop.HintList())
+}
+
+func mockEnrollLoops(t *testing.T) (*NodeManager, sqlmock.Sqlmock) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db, got error: %v", err)
+	}
+	t.Cleanup(func() { mockDB.Close() })
+	_postgres, err := gorm.Open(postgres.New(postgres.Config{Conn: mockDB}), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("unable to create new postgres database: %v", err)
+	}
+	return &NodeManager{DB: _postgres}, mock
+}
+
+func enrollEventRows(events []EnrollEvent) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"id", "uuid", "environment_id", "secret", "osquery_version", "config_hash", "instance_id", "start_time", "ip_address", "created_at"})
+	// Newest first, as queried
+	for i := len(events) - 1; i >= 0; i-- {
+		e := events[i]
+		rows.AddRow(i+1, e.UUID, 1, e.Secret, e.OsqueryVersion, e.ConfigHash, e.InstanceID, e.StartTime, e.IPAddress, e.CreatedAt)
+	}
+	return rows
+}
+
+func TestRecordEnrollLooping(t *testing.T) {
+	n, mock := mockEnrollLoops(t)
+	start := time.Date(2022, 3, 8, 14, 0, 0, 0, time.UTC)
+	events := loopingAgent(start, 7)
+	last := events[6]
+	last.EnvironmentID = 1
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "enroll_events"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectQuery(`SELECT \* FROM "enroll_events" WHERE uuid = \$1 AND environment_id = \$2 AND created_at > \$3 ORDER BY created_at desc, id desc LIMIT 20`).
+		WithArgs("ABC", 1, last.CreatedAt.Add(-EnrollLoopWindow)).WillReturnRows(enrollEventRows(events))
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "enroll_events"`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(7))
+	mock.ExpectQuery(`SELECT \* FROM "enroll_loops"`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery(`INSERT INTO "enroll_loops"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+	loop, err := n.RecordEnroll(last, "host", NewEnrollLoopConfig(5, 0, true))
+	assert.NoError(t, err)
+	assert.True(t, loop.Looping)
+	assert.Equal(t, 7, loop.Enrolls)
+	assert.Equal(t, last.CreatedAt, loop.Since)
+	assert.Contains(t, loop.Hints, "7 osquery instance IDs")
+	// Two enrolls over the threshold
+	assert.Equal(t, last.CreatedAt.Add(2*time.Minute), loop.BlockedUntil)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRecordEnrollUnderThreshold(t *testing.T) {
+	n, mock := mockEnrollLoops(t)
+	start := time.Date(2022, 3, 8, 14, 0, 0, 0, time.UTC)
+	events := loopingAgent(start, 2)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "enroll_events"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	mock.ExpectQuery(`SELECT \* FROM "enroll_events"`).WillReturnRows(enrollEventRows(events))
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "enroll_events"`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mock.ExpectQuery(`SELECT \* FROM "enroll_loops"`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectCommit()
+	loop, err := n.RecordEnroll(events[1], "host", NewEnrollLoopConfig(5, 0, true))
+	assert.NoError(t, err)
+	assert.False(t, loop.Looping)
+	assert.True(t, loop.BlockedUntil.IsZero())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestEnrollBlocked(t *testing.T) {
+	n, mock := mockEnrollLoops(t)
+	now := time.Date(2022, 3, 8, 14, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(`SELECT \* FROM "enroll_loops" WHERE \(uuid = \$1 AND environment_id = \$2 AND looping = \$3 AND blocked_until > \$4\)`).
+		WithArgs("ABC", 1, true, now).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "uuid", "looping", "blocked_until"}).AddRow(1, "ABC", true, now.Add(4*time.Minute)))
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "enroll_loops" SET "blocked"=blocked \+ 1`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	retry, err := n.EnrollBlocked("abc", 1, now)
+	assert.NoError(t, err)
+	assert.Equal(t, 4*time.Minute, retry)
+	// Hosts not looping enroll
+	mock.ExpectQuery(`SELECT \* FROM "enroll_loops"`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	retry, err = n.EnrollBlocked("def", 1, now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), retry)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,64 @@
+package nodes
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+)
+
+// EncodeEnrollMetadata to serialize the enroll metadata of a node to be stored
+func EncodeEnrollMetadata(values map[string]string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	raw, err := json.Marshal(values)
+	if err != nil {
+		log.Printf("error serializing enroll metadata %v", err)
+		return ""
+	}
+	return string(raw)
+}
+
+// EnrollMetadataValues to get the enroll metadata stored in a node, by field name
+func EnrollMetadataValues(node OsqueryNode) map[string]string {
+	values := make(map[string]string)
+	if node.EnrollMetadata == "" {
+		return values
+	}
+	if err := json.Unmarshal([]byte(node.EnrollMetadata), &values); err != nil {
+		log.Printf("error parsing enroll metadata for %s %v", node.UUID, err)
+	}
+	return values
+}
+
+// ParseMetadataFilter to parse a filter by enroll metadata in the format name:value
+func ParseMetadataFilter(filter string) (string, string, bool) {
+	pair := strings.SplitN(filter, ":", 2)
+	if len(pair) != 2 || strings.TrimSpace(pair[0]) == "" {
+		return "", "", false
+	}
+	return strings.ToLower(strings.TrimSpace(pair[0])), strings.TrimSpace(pair[1]), true
+}
+
+// FilterByEnrollMetadata to keep the nodes matching all the filters by enroll metadata.
+// Values are compared case insensitive and an empty value matches nodes without the field.
+func FilterByEnrollMetadata(nodes []OsqueryNode, filters map[string]string) []OsqueryNode {
+	if len(filters) == 0 {
+		return nodes
+	}
+	var filtered []OsqueryNode
+	for _, n := range nodes {
+		values := EnrollMetadataValues(n)
+		match := true
+		for name, value := range filters {
+			if !strings.EqualFold(values[name], value) {
+				match = false
+				break
+			}
+		}
+		if match {
+			filtered = append(filtered, n)
+		}
+	}
+	return filtered
+}
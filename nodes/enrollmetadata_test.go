@@ -0,0 +1,28 @@
+package nodes
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterByEnrollMetadata(t *testing.T) {
+	nds := []OsqueryNode{
+		{UUID: "A", EnrollMetadata: EncodeEnrollMetadata(map[string]string{"owner": "alice", "team": "red"})},
+		{UUID: "B", EnrollMetadata: EncodeEnrollMetadata(map[string]string{"owner": "bob"})},
+		{UUID: "C"},
+	}
+	assert.Len(t, FilterByEnrollMetadata(nds, nil), 3)
+	filtered := FilterByEnrollMetadata(nds, map[string]string{"owner": "Alice"})
+	assert.Len(t, filtered, 1)
+	assert.Equal(t, "A", filtered[0].UUID)
+	// Empty values match nodes without the field
+	filtered = FilterByEnrollMetadata(nds, map[string]string{"team": ""})
+	assert.Len(t, filtered, 2)
+	name, value, ok := ParseMetadataFilter("Owner: alice")
+	assert.True(t, ok)
+	assert.Equal(t, "owner", name)
+	assert.Equal(t, "alice", value)
+	_, _, ok = ParseMetadataFilter("owner")
+	assert.False(t, ok)
+}
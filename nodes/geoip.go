@@ -0,0 +1,357 @@
+package nodes
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	// Maximum number of cached GeoIP lookups
+	defaultGeoIPCache = 10000
+	// Marker that starts the metadata section in a MaxMind DB file
+	mmdbMetadataMarker = "\xAB\xCD\xEFMaxMind.com"
+	// Size of the separator between search tree and data section
+	mmdbDataSeparator = 16
+	// Maximum depth of nested values and pointers when decoding, so corrupted files can not loop
+	mmdbMaxDepth = 64
+)
+
+// GeoIPData to hold the location of an IP address
+type GeoIPData struct {
+	Country string `json:"country"`
+	City    string `json:"city"`
+	ASN     uint   `json:"asn"`
+	ASOrg   string `json:"as_org"`
+}
+
+// GeoIP to resolve IP addresses using MaxMind DB files, with cached lookups
+type GeoIP struct {
+	city  *mmdbReader
+	asn   *mmdbReader
+	mux   sync.Mutex
+	cache map[string]GeoIPData
+}
+
+// CreateGeoIP to initialize GeoIP lookups, any of the files can be empty
+func CreateGeoIP(cityFile, asnFile string) (*GeoIP, error) {
+	g := &GeoIP{cache: make(map[string]GeoIPData)}
+	var err error
+	if cityFile != "" {
+		if g.city, err = openMMDB(cityFile); err != nil {
+			return nil, fmt.Errorf("error loading %s - %v", cityFile, err)
+		}
+	}
+	if asnFile != "" {
+		if g.asn, err = openMMDB(asnFile); err != nil {
+			return nil, fmt.Errorf("error loading %s - %v", asnFile, err)
+		}
+	}
+	return g, nil
+}
+
+// Lookup to resolve one IP address to country, city and ASN
+func (g *GeoIP) Lookup(ipaddress string) (GeoIPData, error) {
+	var data GeoIPData
+	ip := parseClientIP(ipaddress)
+	if ip == nil {
+		return data, fmt.Errorf("invalid IP address %s", ipaddress)
+	}
+	g.mux.Lock()
+	cached, ok := g.cache[ip.String()]
+	g.mux.Unlock()
+	if ok {
+		return cached, nil
+	}
+	if g.city != nil {
+		record, err := g.city.lookup(ip)
+		if err != nil {
+			return data, err
+		}
+		data.Country = mmdbString(record, "country", "iso_code")
+		data.City = mmdbString(record, "city", "names", "en")
+	}
+	if g.asn != nil {
+		record, err := g.asn.lookup(ip)
+		if err != nil {
+			return data, err
+		}
+		if v, ok := record["autonomous_system_number"].(uint64); ok {
+			data.ASN = uint(v)
+		}
+		data.ASOrg = mmdbString(record, "autonomous_system_organization")
+	}
+	g.mux.Lock()
+	if len(g.cache) >= defaultGeoIPCache {
+		g.cache = make(map[string]GeoIPData)
+	}
+	g.cache[ip.String()] = data
+	g.mux.Unlock()
+	return data, nil
+}
+
+// Helper to extract the client IP from values with ports or lists of proxies
+func parseClientIP(ipaddress string) net.IP {
+	ipaddress = strings.TrimSpace(strings.Split(ipaddress, ",")[0])
+	if host, _, err := net.SplitHostPort(ipaddress); err == nil {
+		ipaddress = host
+	}
+	return net.ParseIP(ipaddress)
+}
+
+// Helper to extract a string following the keys of nested maps
+func mmdbString(record map[string]interface{}, keys ...string) string {
+	var current interface{} = record
+	for _, k := range keys {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		current = m[k]
+	}
+	s, _ := current.(string)
+	return s
+}
+
+// mmdbReader is a minimal reader for MaxMind DB files
+type mmdbReader struct {
+	buffer     []byte
+	data       []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	ipv4Start  uint
+}
+
+// Helper to load a MaxMind DB file in memory
+func openMMDB(file string) (*mmdbReader, error) {
+	buffer, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	start := bytes.LastIndex(buffer, []byte(mmdbMetadataMarker))
+	if start == -1 {
+		return nil, fmt.Errorf("metadata not found")
+	}
+	metaBuf := buffer[start+len(mmdbMetadataMarker):]
+	raw, _, err := mmdbDecode(metaBuf, metaBuf, 0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding metadata - %v", err)
+	}
+	meta, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid metadata")
+	}
+	r := &mmdbReader{buffer: buffer}
+	for key, dest := range map[string]*uint{"node_count": &r.nodeCount, "record_size": &r.recordSize, "ip_version": &r.ipVersion} {
+		v, ok := meta[key].(uint64)
+		if !ok {
+			return nil, fmt.Errorf("invalid metadata value %s", key)
+		}
+		*dest = uint(v)
+	}
+	if r.recordSize != 24 && r.recordSize != 28 && r.recordSize != 32 {
+		return nil, fmt.Errorf("unsupported record size %d", r.recordSize)
+	}
+	treeSize := r.nodeCount * r.recordSize / 4
+	if treeSize+mmdbDataSeparator > uint(start) {
+		return nil, fmt.Errorf("invalid search tree size")
+	}
+	r.data = buffer[treeSize+mmdbDataSeparator : start]
+	// IPv4 addresses are stored under ::/96 in IPv6 databases
+	if r.ipVersion == 6 {
+		for i := 0; i < 96 && r.ipv4Start < r.nodeCount; i++ {
+			r.ipv4Start = r.readNode(r.ipv4Start, 0)
+		}
+	}
+	return r, nil
+}
+
+// Helper to read the left (0) or right (1) record of a node
+func (r *mmdbReader) readNode(node, bit uint) uint {
+	offset := node * r.recordSize / 4
+	b := r.buffer[offset:]
+	switch r.recordSize {
+	case 24:
+		o := bit * 3
+		return uint(b[o])<<16 | uint(b[o+1])<<8 | uint(b[o+2])
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xF0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0F)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:]))
+	}
+}
+
+// Helper to search the tree for one IP address and decode its record
+func (r *mmdbReader) lookup(ip net.IP) (map[string]interface{}, error) {
+	node := uint(0)
+	bits := 128
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		bits = 32
+		node = r.ipv4Start
+	} else if r.ipVersion == 4 {
+		return nil, fmt.Errorf("IPv6 lookup in IPv4 database")
+	}
+	for i := 0; i < bits && node < r.nodeCount; i++ {
+		bit := uint(ip[i>>3]>>(7-uint(i%8))) & 1
+		node = r.readNode(node, bit)
+	}
+	if node == r.nodeCount {
+		// Not found
+		return map[string]interface{}{}, nil
+	}
+	if node < r.nodeCount {
+		return nil, fmt.Errorf("invalid search tree")
+	}
+	offset := node - r.nodeCount - mmdbDataSeparator
+	raw, _, err := mmdbDecode(r.data, r.data, offset, 0)
+	if err != nil {
+		return nil, err
+	}
+	record, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid record")
+	}
+	return record, nil
+}
+
+// Helper to decode one value from the data section, returns the next offset
+func mmdbDecode(buf, section []byte, offset, depth uint) (interface{}, uint, error) {
+	if depth > mmdbMaxDepth {
+		return nil, 0, fmt.Errorf("maximum depth exceeded")
+	}
+	if offset >= uint(len(buf)) {
+		return nil, 0, fmt.Errorf("offset %d out of bounds", offset)
+	}
+	ctrl := buf[offset]
+	offset++
+	dtype := uint(ctrl >> 5)
+	// Pointers
+	if dtype == 1 {
+		ss := uint(ctrl>>3) & 0x3
+		vvv := uint(ctrl & 0x7)
+		if offset+ss+1 > uint(len(buf)) {
+			return nil, 0, fmt.Errorf("pointer out of bounds")
+		}
+		b := buf[offset : offset+ss+1]
+		var p uint
+		switch ss {
+		case 0:
+			p = vvv<<8 | uint(b[0])
+		case 1:
+			p = (vvv<<16 | uint(b[0])<<8 | uint(b[1])) + 2048
+		case 2:
+			p = (vvv<<24 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])) + 526336
+		case 3:
+			p = uint(binary.BigEndian.Uint32(b))
+		}
+		v, _, err := mmdbDecode(section, section, p, depth+1)
+		return v, offset + ss + 1, err
+	}
+	// Extended types
+	if dtype == 0 {
+		if offset >= uint(len(buf)) {
+			return nil, 0, fmt.Errorf("extended type out of bounds")
+		}
+		dtype = 7 + uint(buf[offset])
+		offset++
+	}
+	size := uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint(len(buf)) {
+			return nil, 0, fmt.Errorf("size out of bounds")
+		}
+		var extra uint
+		for _, c := range buf[offset : offset+n] {
+			extra = extra<<8 | uint(c)
+		}
+		offset += n
+		switch size {
+		case 29:
+			size = 29 + extra
+		case 30:
+			size = 285 + extra
+		default:
+			size = 65821 + extra
+		}
+	}
+	// Every element of maps and arrays takes at least one byte
+	if (dtype == 7 || dtype == 11) && size > uint(len(buf))-offset {
+		return nil, 0, fmt.Errorf("size out of bounds")
+	}
+	switch dtype {
+	case 7:
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			k, next, err := mmdbDecode(buf, section, offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			v, next, err := mmdbDecode(buf, section, next, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			key, _ := k.(string)
+			m[key] = v
+			offset = next
+		}
+		return m, offset, nil
+	case 11:
+		a := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			v, next, err := mmdbDecode(buf, section, offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, v)
+			offset = next
+		}
+		return a, offset, nil
+	case 14:
+		return size != 0, offset, nil
+	}
+	if offset+size > uint(len(buf)) {
+		return nil, 0, fmt.Errorf("value out of bounds")
+	}
+	b := buf[offset : offset+size]
+	offset += size
+	switch dtype {
+	case 2:
+		return string(b), offset, nil
+	case 3:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("invalid double size")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case 15:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("invalid float size")
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), offset, nil
+	case 4:
+		return b, offset, nil
+	case 5, 6, 9, 10:
+		var v uint64
+		for _, c := range b {
+			v = v<<8 | uint64(c)
+		}
+		return v, offset, nil
+	case 8:
+		var v int32
+		for _, c := range b {
+			v = v<<8 | int32(c)
+		}
+		return int64(v), offset, nil
+	}
+	return nil, offset, fmt.Errorf("unsupported data type %d", dtype)
+}
@@ -0,0 +1,113 @@
+package nodes
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Helpers to encode values of the MaxMind DB data section
+func mmdbTestString(s string) []byte {
+	if len(s) >= 29 {
+		return append([]byte{2<<5 | 29, byte(len(s) - 29)}, s...)
+	}
+	return append([]byte{2<<5 | byte(len(s))}, s...)
+}
+
+func mmdbTestUint(v uint32) []byte {
+	b := []byte{6<<5 | 4, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(b[1:], v)
+	return b
+}
+
+func mmdbTestMap(pairs ...[]byte) []byte {
+	m := []byte{byte(len(pairs) / 2), 0}
+	for _, p := range pairs {
+		m = append(m, p...)
+	}
+	return m
+}
+
+// Helper to write a database with one node, addresses starting with a 0 bit have the record
+func mmdbTestFile(t *testing.T, record []byte) string {
+	var buf bytes.Buffer
+	// Left record points to the data section, right record is not found
+	buf.Write([]byte{0, 0, 1 + mmdbDataSeparator, 0, 0, 1})
+	buf.Write(make([]byte, mmdbDataSeparator))
+	buf.Write(record)
+	buf.WriteString(mmdbMetadataMarker)
+	buf.Write(mmdbTestMap(
+		mmdbTestString("node_count"), mmdbTestUint(1),
+		mmdbTestString("record_size"), mmdbTestUint(24),
+		mmdbTestString("ip_version"), mmdbTestUint(4),
+	))
+	file := filepath.Join(t.TempDir(), "test.mmdb")
+	if err := os.WriteFile(file, buf.Bytes(), 0600); err != nil {
+		t.Fatalf("error writing database - %v", err)
+	}
+	return file
+}
+
+func TestGeoIPLookup(t *testing.T) {
+	city := mmdbTestFile(t, mmdbTestMap(
+		mmdbTestString("country"), mmdbTestMap(mmdbTestString("iso_code"), mmdbTestString("ES")),
+		mmdbTestString("city"), mmdbTestMap(mmdbTestString("names"), mmdbTestMap(mmdbTestString("en"), mmdbTestString("Madrid"))),
+	))
+	asn := mmdbTestFile(t, mmdbTestMap(
+		mmdbTestString("autonomous_system_number"), mmdbTestUint(64512),
+		mmdbTestString("autonomous_system_organization"), mmdbTestString("Example"),
+	))
+	g, err := CreateGeoIP(city, asn)
+	assert.NoError(t, err)
+	data, err := g.Lookup("10.1.2.3:443, 192.168.1.1")
+	assert.NoError(t, err)
+	assert.Equal(t, GeoIPData{Country: "ES", City: "Madrid", ASN: 64512, ASOrg: "Example"}, data)
+	data, err = g.Lookup("200.1.2.3")
+	assert.NoError(t, err)
+	assert.Equal(t, GeoIPData{}, data)
+	_, err = g.Lookup("invalid")
+	assert.Error(t, err)
+	_, err = g.Lookup("2001:db8::1")
+	assert.Error(t, err)
+}
+
+func TestGeoIPInvalidFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "invalid.mmdb")
+	assert.NoError(t, os.WriteFile(file, []byte("not a database"), 0600))
+	_, err := CreateGeoIP(file, "")
+	assert.Error(t, err)
+}
+
+func TestMMDBDecode(t *testing.T) {
+	// Map with a pointer to a string earlier in the section
+	section := append(mmdbTestString("value"), mmdbTestMap(mmdbTestString("key"), []byte{1 << 5, 0})...)
+	v, next, err := mmdbDecode(section, section, 6, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"key": "value"}, v)
+	assert.Equal(t, uint(len(section)), next)
+	// Array, boolean and signed integer
+	array := []byte{2, 4, 1, 7, 4, 1, 0xFF, 0xFF, 0xFF, 0xFF}
+	v, _, err = mmdbDecode(array, array, 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{true, int64(-1)}, v)
+}
+
+func TestMMDBDecodeInvalid(t *testing.T) {
+	deep := bytes.Repeat([]byte{1, 4}, mmdbMaxDepth+1)
+	for name, buf := range map[string][]byte{
+		"pointer to itself":   {1 << 5, 0},
+		"map pointing to map": append([]byte{1, 0}, append(mmdbTestString("k"), 1<<5, 0)...),
+		"nested arrays":       append(deep, mmdbTestString("v")...),
+		"huge map":            {31, 0, 0xFF, 0xFF, 0xFF},
+		"truncated string":    {2<<5 | 10, 'a'},
+		"pointer out of data": {1 << 5, 0xFF},
+		"empty":               {},
+	} {
+		_, _, err := mmdbDecode(buf, buf, 0, 0)
+		assert.Error(t, err, name)
+	}
+}
@@ -0,0 +1,272 @@
+package nodes
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jmpsec/osctrl/backend"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// HistoryTotal is the metric for all the nodes of an environment
+	HistoryTotal string = "total"
+	// HistoryActive is the metric for the active nodes of an environment
+	HistoryActive string = "active"
+	// HistoryInactive is the metric for the inactive nodes of an environment
+	HistoryInactive string = "inactive"
+	// HistoryPlatform is the prefix of the metrics for nodes by platform
+	HistoryPlatform string = "platform:"
+	// HistoryVersion is the prefix of the metrics for nodes by osquery version
+	HistoryVersion string = "version:"
+	// HistoryActivePlatform is the prefix of the metrics for active nodes by platform
+	HistoryActivePlatform string = "active-platform:"
+	// DefaultHistoryRetention is the default days to keep node count history
+	DefaultHistoryRetention int = 1095
+	// HistoryDownsampleDays is the age in days after which only the first snapshot of each month is kept
+	HistoryDownsampleDays int = 365
+	// DefaultHistoryDays is the default days of node count history returned
+	DefaultHistoryDays int = 90
+	// DefaultHistoryInterval is the default interval to take node count snapshots
+	DefaultHistoryInterval = time.Hour
+)
+
+// NodeCountSnapshot to keep the daily count of nodes for one metric of an environment
+type NodeCountSnapshot struct {
+	ID            uint      `gorm:"primaryKey" json:"-"`
+	EnvironmentID uint      `gorm:"uniqueIndex:idx_node_count_snapshot" json:"-"`
+	Day           time.Time `gorm:"uniqueIndex:idx_node_count_snapshot" json:"day"`
+	Metric        string    `gorm:"uniqueIndex:idx_node_count_snapshot" json:"metric"`
+	Count         int64     `json:"count"`
+	CreatedAt     time.Time `json:"-"`
+}
+
+// ValidHistoryMetric to check if a metric can be requested from the node count history
+func ValidHistoryMetric(metric string) bool {
+	switch metric {
+	case HistoryTotal, HistoryActive, HistoryInactive:
+		return true
+	}
+	for _, prefix := range []string{HistoryPlatform, HistoryVersion, HistoryActivePlatform} {
+		if strings.HasPrefix(metric, prefix) && len(metric) > len(prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// HistoryDay to get the day of a snapshot, as midnight in UTC
+func HistoryDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// SnapshotCounts to record the node counts of an environment for one day. Running it again for the
+// same day replaces the counts, so it does not duplicate snapshots.
+func (n *NodeManager) SnapshotCounts(envid uint, day time.Time, hours int64) error {
+	day = HistoryDay(day)
+	stats, err := n.getStatsByEnvID(envid, hours)
+	if err != nil {
+		return fmt.Errorf("getStatsByEnvID %v", err)
+	}
+	snapshots := []NodeCountSnapshot{
+		{EnvironmentID: envid, Day: day, Metric: HistoryTotal, Count: stats.Total},
+		{EnvironmentID: envid, Day: day, Metric: HistoryActive, Count: stats.Active},
+		{EnvironmentID: envid, Day: day, Metric: HistoryInactive, Count: stats.Inactive},
+	}
+	type groupCount struct {
+		Value string
+		Nodes int64
+	}
+	for prefix, column := range map[string]string{HistoryPlatform: "platform", HistoryVersion: "osquery_version"} {
+		var counts []groupCount
+		if err := n.read().Model(&OsqueryNode{}).Select(column+" as value, count(*) as nodes").Where("environment_id = ?", envid).Group(column).Scan(&counts).Error; err != nil {
+			return fmt.Errorf("Group %s %v", column, err)
+		}
+		for _, c := range counts {
+			if c.Value == "" {
+				continue
+			}
+			snapshots = append(snapshots, NodeCountSnapshot{EnvironmentID: envid, Day: day, Metric: prefix + c.Value, Count: c.Nodes})
+		}
+	}
+	var active []groupCount
+	tHours := time.Now().Add(time.Duration(hours) * time.Hour)
+	if err := n.read().Model(&OsqueryNode{}).Select("platform as value, count(*) as nodes").Where("environment_id = ? AND updated_at > ?", envid, tHours).Group("platform").Scan(&active).Error; err != nil {
+		return fmt.Errorf("Group active platform %v", err)
+	}
+	for _, c := range active {
+		if c.Value == "" {
+			continue
+		}
+		snapshots = append(snapshots, NodeCountSnapshot{EnvironmentID: envid, Day: day, Metric: HistoryActivePlatform + c.Value, Count: c.Nodes})
+	}
+	err = n.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "environment_id"}, {Name: "day"}, {Name: "metric"}},
+		DoUpdates: clause.AssignmentColumns([]string{"count"}),
+	}).Create(&snapshots).Error
+	if err != nil {
+		return fmt.Errorf("Create %v", err)
+	}
+	return nil
+}
+
+// Helper to count total, active and inactive nodes of an environment by its ID
+func (n *NodeManager) getStatsByEnvID(envid uint, hours int64) (StatsData, error) {
+	var stats StatsData
+	if err := n.read().Model(&OsqueryNode{}).Where("environment_id = ?", envid).Count(&stats.Total).Error; err != nil {
+		return stats, err
+	}
+	tHours := time.Now().Add(time.Duration(hours) * time.Hour)
+	if err := n.read().Model(&OsqueryNode{}).Where("environment_id = ?", envid).Where("updated_at > ?", tHours).Count(&stats.Active).Error; err != nil {
+		return stats, err
+	}
+	stats.Inactive = stats.Total - stats.Active
+	return stats, nil
+}
+
+// CountHistory to get the snapshots of one metric of an environment between two days, oldest first
+func (n *NodeManager) CountHistory(envid uint, metric string, from, to time.Time) ([]NodeCountSnapshot, error) {
+	var snapshots []NodeCountSnapshot
+	if err := n.read().Where("environment_id = ? AND metric = ? AND day >= ? AND day <= ?", envid, metric, HistoryDay(from), HistoryDay(to)).Order("day").Find(&snapshots).Error; err != nil {
+		return snapshots, fmt.Errorf("Find %v", err)
+	}
+	return snapshots, nil
+}
+
+// LatestCounts to get the counts of the latest snapshot of an environment by metric, with the day of the snapshot.
+// Snapshots are refreshed periodically, so they are cheaper than counting nodes.
+func (n *NodeManager) LatestCounts(envid uint) (map[string]int64, time.Time, error) {
+	counts := make(map[string]int64)
+	var latest NodeCountSnapshot
+	if err := n.read().Where("environment_id = ?", envid).Order("day DESC").Limit(1).Find(&latest).Error; err != nil {
+		return counts, time.Time{}, fmt.Errorf("Find latest %v", err)
+	}
+	if latest.ID == 0 {
+		return counts, time.Time{}, nil
+	}
+	var snapshots []NodeCountSnapshot
+	if err := n.read().Where("environment_id = ? AND day = ?", envid, latest.Day).Find(&snapshots).Error; err != nil {
+		return counts, latest.Day, fmt.Errorf("Find %v", err)
+	}
+	for _, s := range snapshots {
+		counts[s.Metric] = s.Count
+	}
+	return counts, latest.Day, nil
+}
+
+// LatestTotals to get the total nodes of each environment from their latest snapshot, by environment ID
+func (n *NodeManager) LatestTotals() (map[uint]int64, error) {
+	res := make(map[uint]int64)
+	var snapshots []NodeCountSnapshot
+	latest := `SELECT MAX(m.day) FROM node_count_snapshots m WHERE m.environment_id = node_count_snapshots.environment_id AND m.metric = ?`
+	if err := n.read().Where("metric = ? AND day = ("+latest+")", HistoryTotal, HistoryTotal).Find(&snapshots).Error; err != nil {
+		return res, fmt.Errorf("Find %v", err)
+	}
+	for _, s := range snapshots {
+		res[s.EnvironmentID] = s.Count
+	}
+	return res, nil
+}
+
+// CachedCounts to get the counts of the latest snapshot of an environment, taking one when there is none yet
+// or when it was taken before active nodes by platform were counted
+func (n *NodeManager) CachedCounts(envid uint, hours int64) (map[string]int64, time.Time, error) {
+	counts, day, err := n.LatestCounts(envid)
+	if err != nil {
+		return counts, day, err
+	}
+	if len(counts) > 0 && (counts[HistoryActive] == 0 || len(ActivePlatforms(counts)) > 0) {
+		return counts, day, nil
+	}
+	if err := n.SnapshotCounts(envid, time.Now(), hours); err != nil {
+		return counts, day, fmt.Errorf("SnapshotCounts %v", err)
+	}
+	return n.LatestCounts(envid)
+}
+
+// ActivePlatforms to get the active nodes by platform from the counts of a snapshot
+func ActivePlatforms(counts map[string]int64) map[string]int64 {
+	active := make(map[string]int64)
+	for metric, count := range counts {
+		if strings.HasPrefix(metric, HistoryActivePlatform) {
+			active[strings.TrimPrefix(metric, HistoryActivePlatform)] = count
+		}
+	}
+	return active
+}
+
+// PruneHistory to delete snapshots older than the retention in days, 0 keeps them forever, and to keep
+// only the first snapshot of each month for snapshots older than a year
+func (n *NodeManager) PruneHistory(now time.Time, retention int) error {
+	today := HistoryDay(now)
+	return n.DB.Transaction(func(tx *gorm.DB) error {
+		if retention > 0 {
+			if err := tx.Where("day < ?", today.AddDate(0, 0, -retention)).Delete(&NodeCountSnapshot{}).Error; err != nil {
+				return fmt.Errorf("Delete retention %v", err)
+			}
+		}
+		downsample := `DELETE FROM node_count_snapshots s WHERE s.day < ? AND s.day > (
+			SELECT MIN(m.day) FROM node_count_snapshots m WHERE m.environment_id = s.environment_id
+			AND m.metric = s.metric AND date_trunc('month', m.day) = date_trunc('month', s.day))`
+		if err := tx.Exec(downsample, today.AddDate(0, 0, -HistoryDownsampleDays)).Error; err != nil {
+			return fmt.Errorf("Delete downsample %v", err)
+		}
+		return nil
+	})
+}
+
+// RunHistory to snapshot the node counts of all environments for the current day and prune old snapshots.
+// Only the instance holding the leader lock does it, the rest skip it.
+func (n *NodeManager) RunHistory(leader *backend.LeaderLock, envids []uint, hours int64, retention int, now time.Time) {
+	if leader != nil {
+		isLeader, err := leader.Acquire()
+		if err != nil {
+			log.Printf("error acquiring leader lock %v", err)
+			return
+		}
+		if !isLeader {
+			return
+		}
+	}
+	for _, envid := range envids {
+		if err := n.SnapshotCounts(envid, now, hours); err != nil {
+			log.Printf("error taking node count snapshot for environment %d %v", envid, err)
+		}
+	}
+	if err := n.PruneHistory(now, retention); err != nil {
+		log.Printf("error pruning node count history %v", err)
+	}
+}
+
+// StartHistory to take node count snapshots periodically, the latest counts of each day are kept
+func (n *NodeManager) StartHistory(ctx context.Context, leader *backend.LeaderLock, interval time.Duration, envids func() ([]uint, error), hours func() int64, retention func() int) {
+	if interval <= 0 {
+		interval = DefaultHistoryInterval
+	}
+	run := func() {
+		ids, err := envids()
+		if err != nil {
+			log.Printf("error getting environments for history %v", err)
+			return
+		}
+		n.RunHistory(leader, ids, hours(), retention(), time.Now())
+	}
+	go func() {
+		run()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				run()
+			}
+		}
+	}()
+}
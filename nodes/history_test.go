@@ -0,0 +1,67 @@
+package nodes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestValidHistoryMetric(t *testing.T) {
+	assert.True(t, ValidHistoryMetric(HistoryActive))
+	assert.True(t, ValidHistoryMetric("platform:darwin"))
+	assert.True(t, ValidHistoryMetric("version:5.2.2"))
+	assert.True(t, ValidHistoryMetric("active-platform:ubuntu"))
+	assert.False(t, ValidHistoryMetric("platform:"))
+	assert.False(t, ValidHistoryMetric("nodes"))
+}
+
+func TestHistoryDay(t *testing.T) {
+	berlin := time.FixedZone("CET", 3600)
+	day := HistoryDay(time.Date(2022, 3, 1, 0, 30, 0, 0, berlin))
+	assert.Equal(t, time.Date(2022, 2, 28, 0, 0, 0, 0, time.UTC), day)
+}
+
+func TestSnapshotCountsIdempotent(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db, got error: %v", err)
+	}
+	defer mockDB.Close()
+	_postgres, err := gorm.Open(postgres.New(postgres.Config{Conn: mockDB}), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("unable to create new postgres database: %v", err)
+	}
+	n := &NodeManager{DB: _postgres}
+	day := time.Date(2022, 3, 1, 15, 0, 0, 0, time.UTC)
+	// Running twice for the same day upserts the same rows
+	for i := 0; i < 2; i++ {
+		mock.ExpectQuery(`SELECT count\(\*\) FROM "osquery_nodes"`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(10))
+		mock.ExpectQuery(`SELECT count\(\*\) FROM "osquery_nodes"`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(7))
+		mock.ExpectQuery(`SELECT .* as value, count\(\*\) as nodes FROM "osquery_nodes"`).WillReturnRows(sqlmock.NewRows([]string{"value", "nodes"}).AddRow("darwin", 10))
+		mock.ExpectQuery(`SELECT .* as value, count\(\*\) as nodes FROM "osquery_nodes"`).WillReturnRows(sqlmock.NewRows([]string{"value", "nodes"}).AddRow("5.2.2", 10))
+		mock.ExpectQuery(`SELECT platform as value, count\(\*\) as nodes FROM "osquery_nodes" WHERE \(environment_id = \$1 AND updated_at > \$2\)`).WillReturnRows(sqlmock.NewRows([]string{"value", "nodes"}).AddRow("darwin", 7))
+		mock.ExpectBegin()
+		mock.ExpectQuery(`INSERT INTO "node_count_snapshots" .* ON CONFLICT \("environment_id","day","metric"\) DO UPDATE SET "count"="excluded"."count"`).
+			WithArgs(
+				1, time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC), HistoryTotal, 10, sqlmock.AnyArg(),
+				1, sqlmock.AnyArg(), HistoryActive, 7, sqlmock.AnyArg(),
+				1, sqlmock.AnyArg(), HistoryInactive, 3, sqlmock.AnyArg(),
+				1, sqlmock.AnyArg(), sqlmock.AnyArg(), 10, sqlmock.AnyArg(),
+				1, sqlmock.AnyArg(), sqlmock.AnyArg(), 10, sqlmock.AnyArg(),
+				1, sqlmock.AnyArg(), HistoryActivePlatform+"darwin", 7, sqlmock.AnyArg(),
+			).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2).AddRow(3).AddRow(4).AddRow(5).AddRow(6))
+		mock.ExpectCommit()
+		assert.NoError(t, n.SnapshotCounts(1, day, -72))
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestActivePlatforms(t *testing.T) {
+	active := ActivePlatforms(map[string]int64{HistoryActive: 9, "platform:darwin": 10, "active-platform:darwin": 7, "active-platform:ubuntu": 2})
+	assert.Equal(t, map[string]int64{"darwin": 7, "ubuntu": 2}, active)
+}
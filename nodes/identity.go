@@ -0,0 +1,460 @@
+package nodes
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// IdentityPolicyOff for environments without identity binding
+	IdentityPolicyOff string = "off"
+	// IdentityPolicyMonitor for environments where identity mismatches are flagged and notified
+	IdentityPolicyMonitor string = "monitor"
+	// IdentityPolicyEnforce for environments where nodes with identity mismatches do not get queries or carves
+	IdentityPolicyEnforce string = "enforce"
+	// IdentityBound for nodes with an identity that matches the one bound on first use
+	IdentityBound string = "bound"
+	// IdentityMismatch for nodes with an identity that drifted too much or conflicts, until a user resolves it
+	IdentityMismatch string = "mismatch"
+	// IdentityReenroll for nodes forced to enroll again, they are bound again when they enroll
+	IdentityReenroll string = "reenroll"
+	// IdentityAccept to resolve a mismatch binding the node to its new identity
+	IdentityAccept string = "accept"
+	// DefaultIdentityThreshold is the default similarity, in percent, below which identities are mismatched
+	DefaultIdentityThreshold int = 70
+	// Trigger for archived nodes forced to enroll again by an identity mismatch
+	identityTrigger = "identity"
+	// MAC address of interfaces without hardware address
+	identityNullMAC = "00:00:00:00:00:00"
+)
+
+// Weights of the characteristics of an identity for its similarity, MAC addresses change the most legitimately
+const (
+	identityWeightHardware = 40
+	identityWeightPlatform = 15
+	identityWeightCPU      = 15
+	identityWeightMACs     = 30
+)
+
+// IdentityPolicies to list the valid identity policies of environments
+var IdentityPolicies = []string{IdentityPolicyOff, IdentityPolicyMonitor, IdentityPolicyEnforce}
+
+// ValidIdentityPolicy to check if an identity policy is valid, empty is the same as off
+func ValidIdentityPolicy(policy string) bool {
+	if policy == "" {
+		return true
+	}
+	for _, p := range IdentityPolicies {
+		if p == policy {
+			return true
+		}
+	}
+	return false
+}
+
+// IdentityFingerprint with the stable characteristics of the host of a node
+type IdentityFingerprint struct {
+	HardwareUUID string   `json:"hardware_uuid"`
+	Platform     string   `json:"platform"`
+	CPUBrand     string   `json:"cpu_brand"`
+	MACs         []string `json:"mac_addresses"`
+}
+
+// IdentityChange for a characteristic that differs between two fingerprints
+type IdentityChange struct {
+	Field    string
+	Original string
+	Current  string
+}
+
+// NodeIdentity to keep the identity a node was bound to on first use and the last one it reported
+type NodeIdentity struct {
+	gorm.Model
+	UUID          string `gorm:"index"`
+	NodeID        uint
+	EnvironmentID uint `gorm:"index"`
+	Original      string
+	Current       string
+	Similarity    int
+	Conflict      bool
+	State         string
+	CheckedAt     time.Time
+	FlaggedAt     time.Time
+	Resolution    string
+	Resolver      string
+	ResolvedAt    time.Time
+}
+
+// NewIdentityFingerprint to prepare a fingerprint with normalized values, MAC addresses as a comma separated list
+func NewIdentityFingerprint(hardwareUUID, platform, cpuBrand, macs string) IdentityFingerprint {
+	fp := IdentityFingerprint{
+		HardwareUUID: strings.ToUpper(strings.TrimSpace(hardwareUUID)),
+		Platform:     strings.ToLower(strings.TrimSpace(platform)),
+		CPUBrand:     strings.TrimSpace(strings.TrimRight(cpuBrand, "\x00")),
+	}
+	seen := make(map[string]bool)
+	for _, m := range strings.Split(macs, ",") {
+		m = strings.ToLower(strings.TrimSpace(m))
+		if m == "" || m == identityNullMAC || seen[m] {
+			continue
+		}
+		seen[m] = true
+		fp.MACs = append(fp.MACs, m)
+	}
+	sort.Strings(fp.MACs)
+	return fp
+}
+
+// FingerprintFromEnrollment to get the fingerprint of a node from the host_details sent when it enrolled
+func FingerprintFromEnrollment(raw string) IdentityFingerprint {
+	var enroll struct {
+		HostDetails struct {
+			OSVersion struct {
+				Platform string `json:"platform"`
+			} `json:"os_version"`
+			SystemInfo struct {
+				UUID     string `json:"uuid"`
+				CPUBrand string `json:"cpu_brand"`
+			} `json:"system_info"`
+			Interfaces []struct {
+				MAC string `json:"mac"`
+			} `json:"interface_details"`
+		} `json:"host_details"`
+	}
+	if err := json.Unmarshal([]byte(raw), &enroll); err != nil {
+		return IdentityFingerprint{}
+	}
+	var macs []string
+	for _, i := range enroll.HostDetails.Interfaces {
+		macs = append(macs, i.MAC)
+	}
+	return NewIdentityFingerprint(enroll.HostDetails.SystemInfo.UUID, enroll.HostDetails.OSVersion.Platform, enroll.HostDetails.SystemInfo.CPUBrand, strings.Join(macs, ","))
+}
+
+// Empty to check if a fingerprint has no characteristics
+func (fp IdentityFingerprint) Empty() bool {
+	return fp.HardwareUUID == "" && fp.Platform == "" && fp.CPUBrand == "" && len(fp.MACs) == 0
+}
+
+// IdentityConflict to check if two fingerprints belong to different hardware, both with hardware UUID
+func IdentityConflict(original, current IdentityFingerprint) bool {
+	return original.HardwareUUID != "" && current.HardwareUUID != "" && original.HardwareUUID != current.HardwareUUID
+}
+
+// IdentitySimilarity to score in percent how similar two fingerprints are. Only characteristics present in
+// both count, and MAC addresses are compared with the smaller set so new or removed interfaces are tolerated.
+func IdentitySimilarity(original, current IdentityFingerprint) int {
+	var total, score float64
+	compare := func(weight int, a, b string) {
+		if a == "" || b == "" {
+			return
+		}
+		total += float64(weight)
+		if a == b {
+			score += float64(weight)
+		}
+	}
+	compare(identityWeightHardware, original.HardwareUUID, current.HardwareUUID)
+	compare(identityWeightPlatform, original.Platform, current.Platform)
+	compare(identityWeightCPU, original.CPUBrand, current.CPUBrand)
+	if len(original.MACs) > 0 && len(current.MACs) > 0 {
+		total += identityWeightMACs
+		score += identityWeightMACs * macOverlap(original.MACs, current.MACs)
+	}
+	if total == 0 {
+		return 100
+	}
+	return int(score*100/total + 0.5)
+}
+
+// Helper to get the share of the smaller set of MAC addresses that is also in the other set
+func macOverlap(a, b []string) float64 {
+	set := make(map[string]bool, len(a))
+	for _, m := range a {
+		set[m] = true
+	}
+	common := 0
+	for _, m := range b {
+		if set[m] {
+			common++
+		}
+	}
+	smaller := len(a)
+	if len(b) < smaller {
+		smaller = len(b)
+	}
+	return float64(common) / float64(smaller)
+}
+
+// IdentityDiff to get the characteristics that changed from the original fingerprint, unknown current ones are skipped
+func IdentityDiff(original, current IdentityFingerprint) []IdentityChange {
+	var changes []IdentityChange
+	fields := []struct {
+		name              string
+		original, current string
+	}{
+		{"Hardware UUID", original.HardwareUUID, current.HardwareUUID},
+		{"Platform", original.Platform, current.Platform},
+		{"CPU", original.CPUBrand, current.CPUBrand},
+		{"MAC addresses", strings.Join(original.MACs, ", "), strings.Join(current.MACs, ", ")},
+	}
+	for _, f := range fields {
+		if f.current != "" && f.current != f.original {
+			changes = append(changes, IdentityChange{Field: f.name, Original: f.original, Current: f.current})
+		}
+	}
+	return changes
+}
+
+// Helper to decode a serialized fingerprint, empty if it is not valid
+func decodeFingerprint(raw string) IdentityFingerprint {
+	var fp IdentityFingerprint
+	if raw == "" {
+		return fp
+	}
+	if err := json.Unmarshal([]byte(raw), &fp); err != nil {
+		return IdentityFingerprint{}
+	}
+	return fp
+}
+
+// Helper to serialize a fingerprint to keep it in the DB
+func encodeFingerprint(fp IdentityFingerprint) string {
+	raw, err := json.Marshal(fp)
+	if err != nil {
+		return ""
+	}
+	return string(raw)
+}
+
+// OriginalFingerprint to get the fingerprint the node was bound to
+func (i NodeIdentity) OriginalFingerprint() IdentityFingerprint {
+	return decodeFingerprint(i.Original)
+}
+
+// CurrentFingerprint to get the last fingerprint reported by the node
+func (i NodeIdentity) CurrentFingerprint() IdentityFingerprint {
+	return decodeFingerprint(i.Current)
+}
+
+// Diff to get the characteristics that changed since the node was bound
+func (i NodeIdentity) Diff() []IdentityChange {
+	return IdentityDiff(i.OriginalFingerprint(), i.CurrentFingerprint())
+}
+
+// Mismatched to check if the identity of the node needs to be resolved by a user
+func (i NodeIdentity) Mismatched() bool {
+	return i.State == IdentityMismatch
+}
+
+// BindIdentity to bind a node to a fingerprint, replacing any previous identity of the node
+func (n *NodeManager) BindIdentity(node OsqueryNode, fp IdentityFingerprint) (NodeIdentity, error) {
+	identity := NodeIdentity{
+		UUID:          strings.ToUpper(node.UUID),
+		NodeID:        node.ID,
+		EnvironmentID: node.EnvironmentID,
+		Original:      encodeFingerprint(fp),
+		Current:       encodeFingerprint(fp),
+		Similarity:    100,
+		State:         IdentityBound,
+		CheckedAt:     time.Now(),
+	}
+	err := n.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("uuid = ?", identity.UUID).Delete(&NodeIdentity{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&identity).Error
+	})
+	if err != nil {
+		return identity, fmt.Errorf("BindIdentity %v", err)
+	}
+	n.invalidateIdentities()
+	return identity, nil
+}
+
+// GetIdentity to get the identity of a node by UUID
+func (n *NodeManager) GetIdentity(uuid string) (NodeIdentity, error) {
+	var identity NodeIdentity
+	if err := n.read().Where("uuid = ?", strings.ToUpper(uuid)).First(&identity).Error; err != nil {
+		return identity, err
+	}
+	return identity, nil
+}
+
+// CheckIdentity to compare the current fingerprint of a node with the one it is bound to. Nodes without identity
+// are bound to the fingerprint of their enrollment first. Characteristics unknown when the node was bound are
+// trusted on first use. It returns true when the node was flagged with this check, already flagged nodes only
+// get the current fingerprint updated.
+func (n *NodeManager) CheckIdentity(node OsqueryNode, current IdentityFingerprint, threshold int) (NodeIdentity, bool, error) {
+	identity, err := n.GetIdentity(node.UUID)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return identity, false, err
+		}
+		if identity, err = n.BindIdentity(node, FingerprintFromEnrollment(node.RawEnrollment)); err != nil {
+			return identity, false, err
+		}
+	}
+	original := identity.OriginalFingerprint()
+	conflict := IdentityConflict(original, current)
+	if !conflict && identity.State == IdentityBound {
+		if original.HardwareUUID == "" {
+			original.HardwareUUID = current.HardwareUUID
+		}
+		if original.Platform == "" {
+			original.Platform = current.Platform
+		}
+		if original.CPUBrand == "" {
+			original.CPUBrand = current.CPUBrand
+		}
+		if len(original.MACs) == 0 {
+			original.MACs = current.MACs
+		}
+	}
+	similarity := IdentitySimilarity(original, current)
+	updates := map[string]interface{}{
+		"original":   encodeFingerprint(original),
+		"current":    encodeFingerprint(current),
+		"similarity": similarity,
+		"conflict":   conflict,
+		"checked_at": time.Now(),
+	}
+	flagged := identity.State == IdentityBound && (conflict || similarity < threshold)
+	if flagged {
+		updates["state"] = IdentityMismatch
+		updates["flagged_at"] = time.Now()
+	}
+	if err := n.DB.Model(&identity).Updates(updates).Error; err != nil {
+		return identity, false, fmt.Errorf("Updates %v", err)
+	}
+	identity.Original = encodeFingerprint(original)
+	identity.Current = encodeFingerprint(current)
+	identity.Similarity = similarity
+	identity.Conflict = conflict
+	if flagged {
+		identity.State = IdentityMismatch
+		n.invalidateIdentities()
+	}
+	return identity, flagged, nil
+}
+
+// AcceptIdentity to resolve a mismatch binding the node to the last fingerprint it reported
+func (n *NodeManager) AcceptIdentity(uuid, resolver string) (NodeIdentity, error) {
+	identity, err := n.GetIdentity(uuid)
+	if err != nil {
+		return identity, err
+	}
+	if identity.State != IdentityMismatch {
+		return identity, fmt.Errorf("identity of %s is %s and can not be accepted", identity.UUID, identity.State)
+	}
+	if err := n.DB.Model(&identity).Updates(map[string]interface{}{
+		"original":    identity.Current,
+		"similarity":  100,
+		"conflict":    false,
+		"state":       IdentityBound,
+		"resolution":  IdentityAccept,
+		"resolver":    resolver,
+		"resolved_at": time.Now(),
+	}).Error; err != nil {
+		return identity, fmt.Errorf("Updates %v", err)
+	}
+	n.invalidateIdentities()
+	return identity, nil
+}
+
+// ReenrollIdentity to resolve a mismatch forcing the node to enroll again, the node is archived and removed so its
+// node_key is no longer valid, and it is bound to a new identity when it enrolls
+func (n *NodeManager) ReenrollIdentity(uuid, resolver string) (NodeIdentity, error) {
+	identity, err := n.GetIdentity(uuid)
+	if err != nil {
+		return identity, err
+	}
+	if err := n.DB.Model(&identity).Updates(map[string]interface{}{
+		"state":       IdentityReenroll,
+		"resolution":  IdentityReenroll,
+		"resolver":    resolver,
+		"resolved_at": time.Now(),
+	}).Error; err != nil {
+		return identity, fmt.Errorf("Updates %v", err)
+	}
+	n.invalidateIdentities()
+	if err := n.archiveDelete(identity.UUID, identityTrigger, resolver); err != nil {
+		return identity, fmt.Errorf("archiveDelete %v", err)
+	}
+	return identity, nil
+}
+
+// UnbindIdentities to remove the identities of the nodes of an environment, when it stops binding them
+func (n *NodeManager) UnbindIdentities(envid uint) error {
+	if err := n.DB.Where("environment_id = ?", envid).Delete(&NodeIdentity{}).Error; err != nil {
+		return fmt.Errorf("Delete %v", err)
+	}
+	n.invalidateIdentities()
+	return nil
+}
+
+// Helper to force the next read of nodes with identity mismatch from the DB
+func (n *NodeManager) invalidateIdentities() {
+	n.identities.mux.Lock()
+	n.identities.expires = time.Time{}
+	n.identities.mux.Unlock()
+}
+
+// IsIdentityMismatch to check if a node by UUID has an identity mismatch not resolved yet
+func (n *NodeManager) IsIdentityMismatch(uuid string) (bool, error) {
+	n.identities.mux.Lock()
+	defer n.identities.mux.Unlock()
+	if time.Now().After(n.identities.expires) {
+		var uuids []string
+		if err := n.read().Model(&NodeIdentity{}).Where("state = ?", IdentityMismatch).Pluck("uuid", &uuids).Error; err != nil {
+			return false, err
+		}
+		n.identities.uuids = make(map[string]bool, len(uuids))
+		for _, u := range uuids {
+			n.identities.uuids[u] = true
+		}
+		n.identities.expires = time.Now().Add(decommissionCacheTTL)
+	}
+	return n.identities.uuids[strings.ToUpper(uuid)], nil
+}
+
+// NotifyIdentityMismatch to send an identity mismatch as JSON to a webhook, errors are only logged
+func NotifyIdentityMismatch(webhook, environment string, node OsqueryNode, identity NodeIdentity) {
+	if webhook == "" {
+		return
+	}
+	payload, err := json.Marshal(map[string]interface{}{
+		"event":       "identity_mismatch",
+		"environment": environment,
+		"uuid":        node.UUID,
+		"hostname":    node.Hostname,
+		"ip_address":  node.IPAddress,
+		"similarity":  identity.Similarity,
+		"conflict":    identity.Conflict,
+		"original":    identity.OriginalFingerprint(),
+		"current":     identity.CurrentFingerprint(),
+	})
+	if err != nil {
+		log.Printf("error serializing identity mismatch %v", err)
+		return
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(webhook, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		log.Printf("error notifying identity mismatch %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("error notifying identity mismatch, webhook returned %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,75 @@
+package nodes
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	identityHardware = "4C4C4544-0042-3510-8052-B4C04F4E4B32"
+	identityCPU      = "Intel(R) Core(TM) i7-8650U CPU @ 1.90GHz"
+)
+
+func TestNewIdentityFingerprint(t *testing.T) {
+	fp := NewIdentityFingerprint(" 4c4c4544-0042-3510-8052-b4c04f4e4b32", "Darwin", identityCPU+"\x00\x00", "A4:83:E7:01:02:03,,00:00:00:00:00:00, a4:83:e7:01:02:03,3c:22:fb:aa:bb:cc")
+	assert.Equal(t, identityHardware, fp.HardwareUUID)
+	assert.Equal(t, "darwin", fp.Platform)
+	assert.Equal(t, identityCPU, fp.CPUBrand)
+	assert.Equal(t, []string{"3c:22:fb:aa:bb:cc", "a4:83:e7:01:02:03"}, fp.MACs)
+	assert.True(t, IdentityFingerprint{}.Empty())
+}
+
+func TestFingerprintFromEnrollment(t *testing.T) {
+	raw := `{"host_identifier":"` + identityHardware + `","host_details":{"os_version":{"platform":"ubuntu"},"system_info":{"uuid":"` + identityHardware + `","cpu_brand":"` + identityCPU + `"}}}`
+	fp := FingerprintFromEnrollment(raw)
+	assert.Equal(t, identityHardware, fp.HardwareUUID)
+	assert.Equal(t, "ubuntu", fp.Platform)
+	assert.Equal(t, identityCPU, fp.CPUBrand)
+	assert.Empty(t, fp.MACs)
+	assert.True(t, FingerprintFromEnrollment("not json").Empty())
+}
+
+func TestIdentitySimilarity(t *testing.T) {
+	original := NewIdentityFingerprint(identityHardware, "darwin", identityCPU, "a4:83:e7:01:02:03,3c:22:fb:aa:bb:cc")
+	cases := []struct {
+		name     string
+		current  IdentityFingerprint
+		conflict bool
+		mismatch bool
+	}{
+		{"same host", NewIdentityFingerprint(identityHardware, "darwin", identityCPU, "3c:22:fb:aa:bb:cc,a4:83:e7:01:02:03"), false, false},
+		{"new usb ethernet adapter", NewIdentityFingerprint(identityHardware, "darwin", identityCPU, "a4:83:e7:01:02:03,3c:22:fb:aa:bb:cc,00:e0:4c:68:01:02"), false, false},
+		{"wifi card replaced", NewIdentityFingerprint(identityHardware, "darwin", identityCPU, "a4:83:e7:01:02:03,f0:18:98:11:22:33"), false, false},
+		{"docker and vpn interfaces", NewIdentityFingerprint(identityHardware, "darwin", identityCPU, "a4:83:e7:01:02:03,02:42:ac:11:00:02,02:42:9e:3b:1a:01,0a:00:27:00:00:00"), false, false},
+		{"all interfaces replaced", NewIdentityFingerprint(identityHardware, "darwin", identityCPU, "f0:18:98:11:22:33"), false, false},
+		{"interfaces down", NewIdentityFingerprint(identityHardware, "darwin", identityCPU, ""), false, false},
+		{"vm moved to another cpu", NewIdentityFingerprint(identityHardware, "darwin", "Intel(R) Xeon(R) Platinum 8259CL CPU @ 2.50GHz", "a4:83:e7:01:02:03,3c:22:fb:aa:bb:cc"), false, false},
+		{"other host with same platform", NewIdentityFingerprint("", "darwin", "Apple M1", "f0:18:98:11:22:33"), false, true},
+		{"other hardware", NewIdentityFingerprint("564D1A2B-3C4D-5E6F-7A8B-9C0D1E2F3A4B", "darwin", identityCPU, "a4:83:e7:01:02:03,3c:22:fb:aa:bb:cc"), true, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			similarity := IdentitySimilarity(original, c.current)
+			assert.Equal(t, c.conflict, IdentityConflict(original, c.current))
+			assert.Equal(t, c.mismatch, similarity < DefaultIdentityThreshold, "similarity %d", similarity)
+		})
+	}
+	assert.Equal(t, 100, IdentitySimilarity(IdentityFingerprint{}, original))
+}
+
+func TestIdentityDiff(t *testing.T) {
+	original := NewIdentityFingerprint(identityHardware, "darwin", identityCPU, "a4:83:e7:01:02:03")
+	current := NewIdentityFingerprint(identityHardware, "darwin", "", "a4:83:e7:01:02:03,f0:18:98:11:22:33")
+	changes := IdentityDiff(original, current)
+	assert.Equal(t, []IdentityChange{{Field: "MAC addresses", Original: "a4:83:e7:01:02:03", Current: "a4:83:e7:01:02:03, f0:18:98:11:22:33"}}, changes)
+	identity := NodeIdentity{Original: encodeFingerprint(original), Current: encodeFingerprint(current), State: IdentityMismatch}
+	assert.Equal(t, changes, identity.Diff())
+	assert.True(t, identity.Mismatched())
+}
+
+func TestValidIdentityPolicy(t *testing.T) {
+	assert.True(t, ValidIdentityPolicy(""))
+	assert.True(t, ValidIdentityPolicy(IdentityPolicyEnforce))
+	assert.False(t, ValidIdentityPolicy("strict"))
+}
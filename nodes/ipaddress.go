@@ -2,41 +2,43 @@ package nodes
 
 import (
 	"fmt"
+	"log"
 	"time"
 
 	"gorm.io/gorm"
 )
 
+const (
+	// DefaultMaxIPHistory is the default number of distinct IP addresses kept per node
+	DefaultMaxIPHistory = 50
+	// Size of the queue of IP addresses pending GeoIP enrichment
+	defaultGeoQueue = 1000
+)
+
 // NodeHistoryIPAddress to keep track of all IP Addresses for nodes
 type NodeHistoryIPAddress struct {
 	gorm.Model
 	UUID      string `gorm:"index"`
 	IPAddress string
 	Count     int
+	FirstSeen time.Time
+	LastSeen  time.Time
+	Country   string
+	City      string
+	ASN       uint
+	ASOrg     string
 }
 
 // UpdateIPAddress to update the node IP Address
 func (n *NodeManager) UpdateIPAddress(ipaddress string, node OsqueryNode) error {
-	data := OsqueryNode{
-		IPAddress: "",
+	if err := n.RecordIPAddress(ipaddress, node); err != nil {
+		return fmt.Errorf("recordIPAddress %v", err)
 	}
 	if (ipaddress != "") && (ipaddress != node.IPAddress) {
-		data.IPAddress = ipaddress
-		e := NodeHistoryIPAddress{
-			UUID:      node.UUID,
-			IPAddress: ipaddress,
-			Count:     1,
-		}
-		if err := n.NewHistoryIPAddress(e); err != nil {
-			return fmt.Errorf("newNodeHistoryIPAddress %v", err)
-		}
-		if err := n.DB.Model(&node).Updates(data).Error; err != nil {
+		if err := n.DB.Model(&node).Updates(OsqueryNode{IPAddress: ipaddress}).Error; err != nil {
 			return fmt.Errorf("Updates %v", err)
 		}
 	} else {
-		if err := n.IncHistoryIPAddress(node.UUID, ipaddress); err != nil {
-			return fmt.Errorf("incNodeHistoryIPAddress %v", err)
-		}
 		if err := n.DB.Model(&node).Update("updated_at", time.Now()).Error; err != nil {
 			return fmt.Errorf("Update %v", err)
 		}
@@ -50,17 +52,23 @@ func (n *NodeManager) RecordIPAddress(ipaddress string, node OsqueryNode) error
 		return nil
 	}
 	if !n.SeenIPAddress(node.UUID, ipaddress) {
+		now := time.Now()
 		e := NodeHistoryIPAddress{
 			UUID:      node.UUID,
 			IPAddress: ipaddress,
 			Count:     1,
+			FirstSeen: now,
+			LastSeen:  now,
 		}
 		if err := n.NewHistoryIPAddress(e); err != nil {
 			return fmt.Errorf("newNodeHistoryIPAddress %v", err)
 		}
+		if err := n.EvictHistoryIPAddress(node.UUID); err != nil {
+			return fmt.Errorf("evictNodeHistoryIPAddress %v", err)
+		}
 	} else {
 		if err := n.IncHistoryIPAddress(node.UUID, ipaddress); err != nil {
-			return fmt.Errorf("newNodeHistoryIPAddress %v", err)
+			return fmt.Errorf("incNodeHistoryIPAddress %v", err)
 		}
 	}
 	return nil
@@ -89,6 +97,14 @@ func (n *NodeManager) NewHistoryIPAddress(entry NodeHistoryIPAddress) error {
 	if err := n.DB.Create(&entry).Error; err != nil {
 		return err
 	}
+	// Enrich asynchronously, never blocking the request
+	if n.geoQueue != nil {
+		select {
+		case n.geoQueue <- entry:
+		default:
+			log.Printf("GeoIP queue is full, skipping %s", entry.IPAddress)
+		}
+	}
 	return nil
 }
 
@@ -101,14 +117,43 @@ func (n *NodeManager) GetHistoryIPAddress(uuid, ipaddress string) (NodeHistoryIP
 	return nodeip, nil
 }
 
+// GetHistoryIPAddresses to retrieve all the History IP Address records by UUID, most recent first
+func (n *NodeManager) GetHistoryIPAddresses(uuid string) ([]NodeHistoryIPAddress, error) {
+	var history []NodeHistoryIPAddress
+	if err := n.read().Where("uuid = ?", uuid).Order("last_seen DESC").Find(&history).Error; err != nil {
+		return history, err
+	}
+	return history, nil
+}
+
 // IncHistoryIPAddress to increase the count for this IP Address
 func (n *NodeManager) IncHistoryIPAddress(uuid, ipaddress string) error {
 	nodeip, err := n.GetHistoryIPAddress(uuid, ipaddress)
 	if err != nil {
 		return fmt.Errorf("getNodeHistoryIPAddress %v", err)
 	}
-	if err := n.DB.Model(&nodeip).Update("count", nodeip.Count+1).Error; err != nil {
-		return fmt.Errorf("Update %v", err)
+	if err := n.DB.Model(&nodeip).Updates(map[string]interface{}{
+		"count":     nodeip.Count + 1,
+		"last_seen": time.Now(),
+	}).Error; err != nil {
+		return fmt.Errorf("Updates %v", err)
+	}
+	return nil
+}
+
+// EvictHistoryIPAddress to remove the least recently seen IP Addresses over the limit
+func (n *NodeManager) EvictHistoryIPAddress(uuid string) error {
+	if n.MaxIPHistory <= 0 {
+		return nil
+	}
+	var evict []NodeHistoryIPAddress
+	if err := n.DB.Where("uuid = ?", uuid).Order("last_seen DESC").Offset(n.MaxIPHistory).Find(&evict).Error; err != nil {
+		return err
+	}
+	for _, e := range evict {
+		if err := n.DB.Unscoped().Delete(&e).Error; err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -119,3 +164,33 @@ func (n *NodeManager) SeenIPAddress(uuid, ipaddress string) bool {
 	n.DB.Model(&NodeHistoryIPAddress{}).Where("uuid = ? AND ip_address = ?", uuid, ipaddress).Count(&results)
 	return (results > 0)
 }
+
+// EnableGeoIP to start enriching new IP Addresses with GeoIP data
+func (n *NodeManager) EnableGeoIP(geoip *GeoIP) {
+	n.GeoIP = geoip
+	n.geoQueue = make(chan NodeHistoryIPAddress, defaultGeoQueue)
+	go func() {
+		for entry := range n.geoQueue {
+			if err := n.EnrichHistoryIPAddress(entry); err != nil {
+				log.Printf("error enriching IP address %s - %v", entry.IPAddress, err)
+			}
+		}
+	}()
+}
+
+// EnrichHistoryIPAddress to add GeoIP data to one entry of the history of IP Addresses
+func (n *NodeManager) EnrichHistoryIPAddress(entry NodeHistoryIPAddress) error {
+	geo, err := n.GeoIP.Lookup(entry.IPAddress)
+	if err != nil {
+		return err
+	}
+	if err := n.DB.Model(&NodeHistoryIPAddress{}).Where("uuid = ? AND ip_address = ?", entry.UUID, entry.IPAddress).Updates(map[string]interface{}{
+		"country": geo.Country,
+		"city":    geo.City,
+		"asn":     geo.ASN,
+		"as_org":  geo.ASOrg,
+	}).Error; err != nil {
+		return fmt.Errorf("Updates %v", err)
+	}
+	return nil
+}
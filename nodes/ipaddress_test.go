@@ -0,0 +1,46 @@
+package nodes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvictHistoryIPAddress(t *testing.T) {
+	n, mock := newNodesMock(t)
+	n.MaxIPHistory = 2
+	// Addresses past the limit are the least recently seen
+	mock.ExpectQuery(`SELECT \* FROM "node_history_ip_addresses" WHERE uuid = \$1 AND "node_history_ip_addresses"."deleted_at" IS NULL ORDER BY last_seen DESC OFFSET 2`).
+		WithArgs("node-uuid").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "uuid", "ip_address", "last_seen"}).
+			AddRow(3, "node-uuid", "10.0.0.3", time.Now().Add(-time.Hour)).
+			AddRow(4, "node-uuid", "10.0.0.4", time.Now().Add(-2*time.Hour)))
+	for _, id := range []int{3, 4} {
+		mock.ExpectBegin()
+		mock.ExpectExec(`DELETE FROM "node_history_ip_addresses" WHERE "node_history_ip_addresses"."id" = \$1`).
+			WithArgs(id).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	}
+	assert.NoError(t, n.EvictHistoryIPAddress("node-uuid"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestEvictHistoryIPAddressUnderLimit(t *testing.T) {
+	n, mock := newNodesMock(t)
+	n.MaxIPHistory = 2
+	mock.ExpectQuery(`SELECT \* FROM "node_history_ip_addresses" WHERE uuid = \$1 AND "node_history_ip_addresses"."deleted_at" IS NULL ORDER BY last_seen DESC OFFSET 2`).
+		WithArgs("node-uuid").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	assert.NoError(t, n.EvictHistoryIPAddress("node-uuid"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestEvictHistoryIPAddressUnlimited(t *testing.T) {
+	n, mock := newNodesMock(t)
+	// Without a limit the history is not queried
+	assert.NoError(t, n.EvictHistoryIPAddress("node-uuid"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
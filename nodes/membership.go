@@ -0,0 +1,230 @@
+package nodes
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/jmpsec/osctrl/backend"
+	"gorm.io/gorm"
+)
+
+const (
+	// MembershipEnvironment is the entity of membership changes of environments
+	MembershipEnvironment string = "environment"
+	// MembershipTag is the entity of membership changes of tags
+	MembershipTag string = "tag"
+	// MembershipAdded is the action when a node joins an environment or it is tagged
+	MembershipAdded string = "added"
+	// MembershipRemoved is the action when a node leaves an environment or it is untagged
+	MembershipRemoved string = "removed"
+	// MembershipEnrollActor is the actor of membership changes done by enrolling nodes
+	MembershipEnrollActor string = "enroll"
+	// MembershipRetentionActor is the actor of the memberships kept when older history is pruned
+	MembershipRetentionActor string = "retention"
+	// DefaultMembershipRetention is the default days to keep membership history, it is compliance evidence
+	DefaultMembershipRetention int = 3650
+	// DefaultMembershipInterval is the default interval to prune membership history
+	DefaultMembershipInterval = 24 * time.Hour
+)
+
+// NodeMembership to keep the history of the environments and tags of nodes
+type NodeMembership struct {
+	ID        uint      `gorm:"primaryKey" json:"-"`
+	NodeID    uint      `gorm:"index" json:"node_id"`
+	UUID      string    `gorm:"index" json:"uuid"`
+	Entity    string    `json:"entity"`
+	Name      string    `json:"name"`
+	Action    string    `json:"action"`
+	Actor     string    `json:"actor"`
+	CreatedAt time.Time `gorm:"index" json:"timestamp"`
+}
+
+// MembershipState to hold the environments and tags of a node at one point in time
+type MembershipState struct {
+	UUID         string    `json:"uuid"`
+	At           time.Time `json:"at"`
+	Environments []string  `json:"environments"`
+	Tags         []string  `json:"tags"`
+}
+
+// MembershipAt to replay membership changes, sorted by time, to get the state at one point in time
+func MembershipAt(uuid string, changes []NodeMembership, at time.Time) MembershipState {
+	envs := make(map[string]bool)
+	tags := make(map[string]bool)
+	for _, c := range changes {
+		if c.CreatedAt.After(at) {
+			break
+		}
+		set := tags
+		if c.Entity == MembershipEnvironment {
+			set = envs
+		}
+		if c.Action == MembershipAdded {
+			set[c.Name] = true
+		} else {
+			delete(set, c.Name)
+		}
+	}
+	return MembershipState{UUID: uuid, At: at, Environments: sortedNames(envs), Tags: sortedNames(tags)}
+}
+
+// Helper to get the names of a set, sorted
+func sortedNames(set map[string]bool) []string {
+	names := make([]string, 0, len(set))
+	for n := range set {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CompactMembership to replace the changes of a node before the cutoff with the memberships it still had
+// at the cutoff, so states after the cutoff are the same once older changes are pruned
+func CompactMembership(nodeID uint, uuid string, changes []NodeMembership, cutoff time.Time) []NodeMembership {
+	state := MembershipAt(uuid, changes, cutoff)
+	var baseline []NodeMembership
+	for _, e := range state.Environments {
+		baseline = append(baseline, NodeMembership{NodeID: nodeID, UUID: uuid, Entity: MembershipEnvironment, Name: e, Action: MembershipAdded, Actor: MembershipRetentionActor, CreatedAt: cutoff})
+	}
+	for _, t := range state.Tags {
+		baseline = append(baseline, NodeMembership{NodeID: nodeID, UUID: uuid, Entity: MembershipTag, Name: t, Action: MembershipAdded, Actor: MembershipRetentionActor, CreatedAt: cutoff})
+	}
+	return baseline
+}
+
+// RecordMembership to write membership changes, with the transaction of the change so none is missed
+func RecordMembership(tx *gorm.DB, changes ...NodeMembership) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	if err := tx.Create(&changes).Error; err != nil {
+		return fmt.Errorf("Create NodeMembership %v", err)
+	}
+	return nil
+}
+
+// RecordNodesMembership to write the same membership change for many nodes by ID, with the transaction of the change
+func RecordNodesMembership(tx *gorm.DB, nodeIDs []uint, entity, name, action, actor string) error {
+	if len(nodeIDs) == 0 {
+		return nil
+	}
+	var found []OsqueryNode
+	if err := tx.Select("id", "uuid").Where("id IN ?", nodeIDs).Find(&found).Error; err != nil {
+		return fmt.Errorf("Find %v", err)
+	}
+	changes := make([]NodeMembership, 0, len(found))
+	for _, n := range found {
+		changes = append(changes, NodeMembership{NodeID: n.ID, UUID: n.UUID, Entity: entity, Name: name, Action: action, Actor: actor})
+	}
+	return RecordMembership(tx, changes...)
+}
+
+// Helper to get the membership change of an environment for a node
+func envMembership(node OsqueryNode, environment, action, actor string) NodeMembership {
+	return NodeMembership{
+		NodeID: node.ID,
+		UUID:   node.UUID,
+		Entity: MembershipEnvironment,
+		Name:   environment,
+		Action: action,
+		Actor:  actor,
+	}
+}
+
+// GetMembershipAt to get the environments and tags of a node by UUID at one point in time
+func (n *NodeManager) GetMembershipAt(uuid string, at time.Time) (MembershipState, error) {
+	var changes []NodeMembership
+	if err := n.read().Where("uuid = ? AND created_at <= ?", uuid, at).Order("created_at, id").Find(&changes).Error; err != nil {
+		return MembershipState{}, err
+	}
+	return MembershipAt(uuid, changes, at), nil
+}
+
+// GetMembershipChanges to get the membership changes of a node by UUID within a window
+func (n *NodeManager) GetMembershipChanges(uuid string, from, to time.Time) ([]NodeMembership, error) {
+	var changes []NodeMembership
+	if err := n.read().Where("uuid = ? AND created_at >= ? AND created_at <= ?", uuid, from, to).Order("created_at, id").Find(&changes).Error; err != nil {
+		return changes, err
+	}
+	return changes, nil
+}
+
+// PruneMembership to delete membership changes older than the retention in days, 0 keeps them forever.
+// Memberships nodes still had at the cutoff are kept, so later states do not change.
+func (n *NodeManager) PruneMembership(now time.Time, retention int) error {
+	if retention <= 0 {
+		return nil
+	}
+	cutoff := now.AddDate(0, 0, -retention)
+	return n.DB.Transaction(func(tx *gorm.DB) error {
+		var old []NodeMembership
+		if err := tx.Where("created_at < ?", cutoff).Order("created_at, id").Find(&old).Error; err != nil {
+			return fmt.Errorf("Find %v", err)
+		}
+		if len(old) == 0 {
+			return nil
+		}
+		byUUID := make(map[string][]NodeMembership)
+		for _, c := range old {
+			byUUID[c.UUID] = append(byUUID[c.UUID], c)
+		}
+		for uuid, changes := range byUUID {
+			// Nodes with only memberships kept from previous prunes are already compacted
+			ids := make([]uint, 0, len(changes))
+			compacted := true
+			for _, c := range changes {
+				ids = append(ids, c.ID)
+				compacted = compacted && c.Actor == MembershipRetentionActor
+			}
+			if compacted {
+				continue
+			}
+			if err := tx.Where("id IN ?", ids).Delete(&NodeMembership{}).Error; err != nil {
+				return fmt.Errorf("Delete %v", err)
+			}
+			last := changes[len(changes)-1]
+			if err := RecordMembership(tx, CompactMembership(last.NodeID, uuid, changes, cutoff)...); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// StartMembershipPrune to prune membership history periodically, only by the instance holding the leader lock
+func (n *NodeManager) StartMembershipPrune(ctx context.Context, leader *backend.LeaderLock, interval time.Duration, retention func() int) {
+	if interval <= 0 {
+		interval = DefaultMembershipInterval
+	}
+	run := func() {
+		if leader != nil {
+			isLeader, err := leader.Acquire()
+			if err != nil {
+				log.Printf("error acquiring leader lock %v", err)
+				return
+			}
+			if !isLeader {
+				return
+			}
+		}
+		if err := n.PruneMembership(time.Now(), retention()); err != nil {
+			log.Printf("error pruning membership history %v", err)
+		}
+	}
+	go func() {
+		run()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				run()
+			}
+		}
+	}()
+}
@@ -0,0 +1,130 @@
+package nodes
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func membershipChanges() []NodeMembership {
+	day := func(d int) time.Time { return time.Date(2024, 7, d, 0, 0, 0, 0, time.UTC) }
+	return []NodeMembership{
+		{UUID: "UUID1", Entity: MembershipEnvironment, Name: "dev", Action: MembershipAdded, CreatedAt: day(1)},
+		{UUID: "UUID1", Entity: MembershipTag, Name: "cde", Action: MembershipAdded, CreatedAt: day(2)},
+		{UUID: "UUID1", Entity: MembershipEnvironment, Name: "dev", Action: MembershipRemoved, CreatedAt: day(3)},
+		{UUID: "UUID1", Entity: MembershipEnvironment, Name: "pci", Action: MembershipAdded, CreatedAt: day(3)},
+		{UUID: "UUID1", Entity: MembershipTag, Name: "web", Action: MembershipAdded, CreatedAt: day(4)},
+		{UUID: "UUID1", Entity: MembershipTag, Name: "cde", Action: MembershipRemoved, CreatedAt: day(5)},
+	}
+}
+
+func TestMembershipAt(t *testing.T) {
+	changes := membershipChanges()
+	state := MembershipAt("UUID1", changes, time.Date(2024, 6, 30, 0, 0, 0, 0, time.UTC))
+	assert.Empty(t, state.Environments)
+	assert.Empty(t, state.Tags)
+	state = MembershipAt("UUID1", changes, time.Date(2024, 7, 2, 12, 0, 0, 0, time.UTC))
+	assert.Equal(t, []string{"dev"}, state.Environments)
+	assert.Equal(t, []string{"cde"}, state.Tags)
+	state = MembershipAt("UUID1", changes, time.Date(2024, 7, 4, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, []string{"pci"}, state.Environments)
+	assert.Equal(t, []string{"cde", "web"}, state.Tags)
+	state = MembershipAt("UUID1", changes, time.Date(2024, 8, 1, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, []string{"pci"}, state.Environments)
+	assert.Equal(t, []string{"web"}, state.Tags)
+}
+
+func TestCompactMembership(t *testing.T) {
+	changes := membershipChanges()
+	cutoff := time.Date(2024, 7, 4, 12, 0, 0, 0, time.UTC)
+	baseline := CompactMembership(1, "UUID1", changes[:5], cutoff)
+	assert.Len(t, baseline, 3)
+	for _, b := range baseline {
+		assert.Equal(t, MembershipAdded, b.Action)
+		assert.Equal(t, MembershipRetentionActor, b.Actor)
+		assert.Equal(t, cutoff, b.CreatedAt)
+	}
+	// States after the cutoff are the same with the baseline instead of the older changes
+	later := time.Date(2024, 8, 1, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, MembershipAt("UUID1", changes, later), MembershipAt("UUID1", append(baseline, changes[5]), later))
+}
+
+func mockNodes(t *testing.T) (*NodeManager, sqlmock.Sqlmock, func()) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db, got error: %v", err)
+	}
+	_postgres, err := gorm.Open(postgres.New(postgres.Config{Conn: mockDB}), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("unable to create new postgres database: %v", err)
+	}
+	return &NodeManager{DB: _postgres}, mock, func() { mockDB.Close() }
+}
+
+func nodeRow(env string, envid uint) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "uuid", "environment", "environment_id"}).AddRow(7, "UUID1", env, envid)
+}
+
+func TestUpdateByUUIDRecordsEnvironment(t *testing.T) {
+	n, mock, done := mockNodes(t)
+	defer done()
+	mock.ExpectQuery(`SELECT \* FROM "osquery_nodes"`).WillReturnRows(nodeRow("dev", 1))
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "osquery_nodes"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO "node_memberships"`).
+		WithArgs(
+			7, "UUID1", MembershipEnvironment, "dev", MembershipRemoved, MembershipEnrollActor, sqlmock.AnyArg(),
+			7, "UUID1", MembershipEnvironment, "pci", MembershipAdded, MembershipEnrollActor, sqlmock.AnyArg(),
+		).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+	mock.ExpectCommit()
+	assert.NoError(t, n.UpdateByUUID(OsqueryNode{Environment: "pci", EnvironmentID: 2}, "UUID1"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateByUUIDSameEnvironment(t *testing.T) {
+	n, mock, done := mockNodes(t)
+	defer done()
+	// Enrolling again in the same environment is not a change
+	mock.ExpectQuery(`SELECT \* FROM "osquery_nodes"`).WillReturnRows(nodeRow("dev", 1))
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "osquery_nodes"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	assert.NoError(t, n.UpdateByUUID(OsqueryNode{Environment: "dev", EnvironmentID: 1}, "UUID1"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateByUUIDNotRecordedRollsBack(t *testing.T) {
+	n, mock, done := mockNodes(t)
+	defer done()
+	// The node does not move if its membership change can not be recorded
+	mock.ExpectQuery(`SELECT \* FROM "osquery_nodes"`).WillReturnRows(nodeRow("dev", 1))
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "osquery_nodes"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO "node_memberships"`).WillReturnError(fmt.Errorf("insert failed"))
+	mock.ExpectRollback()
+	assert.Error(t, n.UpdateByUUID(OsqueryNode{Environment: "pci", EnvironmentID: 2}, "UUID1"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestArchiveDeleteRecordsEnvironment(t *testing.T) {
+	n, mock, done := mockNodes(t)
+	defer done()
+	mock.ExpectQuery(`SELECT \* FROM "osquery_nodes"`).WillReturnRows(nodeRow("pci", 2))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "archive_osquery_nodes"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM "osquery_nodes"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO "node_memberships"`).
+		WithArgs(7, "UUID1", MembershipEnvironment, "pci", MembershipRemoved, "admin", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+	assert.NoError(t, n.ArchiveDeleteByUUID("UUID1", "admin"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
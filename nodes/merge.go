@@ -0,0 +1,710 @@
+package nodes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmpsec/osctrl/backend"
+	"gorm.io/gorm"
+)
+
+const (
+	// MergeProposed for duplicate candidates waiting for review
+	MergeProposed string = "proposed"
+	// MergeRejected for candidates that a user decided are different hosts, they are not proposed again
+	MergeRejected string = "rejected"
+	// MergeMerged for duplicates merged into their canonical node
+	MergeMerged string = "merged"
+	// MergeSplit for merges reverted, the duplicate node is restored with its records
+	MergeSplit string = "split"
+	// MergeJobProposer for candidates proposed by the scoring job
+	MergeJobProposer string = "scoring"
+	// DefaultMergeScore is the default score, in percent, to propose two nodes as duplicates
+	DefaultMergeScore int = 60
+	// DefaultMergeHostnameDistance is the default number of edits for hostnames to be near-identical
+	DefaultMergeHostnameDistance int = 2
+	// DefaultMergeSplitDays is the default days a merge can be split back
+	DefaultMergeSplitDays int = 30
+	// DefaultMergeInterval is the default interval to look for duplicate nodes
+	DefaultMergeInterval = 24 * time.Hour
+	// Trigger for archived nodes that were merged into another node
+	mergeTrigger = "merge"
+	// Rows re-pointed with each update
+	mergeBatch = 1000
+	// Nodes sharing the beginning of the hostname over this are not scored by hostname, like cloud instances
+	mergeMaxHostnameGroup = 500
+)
+
+// Weights of the signals for two nodes being the same host, the hardware is the strongest
+const (
+	mergeWeightHardware = 50
+	mergeWeightSerial   = 30
+	mergeWeightMACs     = 20
+	mergeWeightHostname = 15
+	mergeWeightActivity = 15
+	mergeWeightOverlap  = 30
+)
+
+// MergeRef for a column of another table that points to a node, by UUID or by ID. Rows are re-pointed by
+// table name so this package does not need the models of the other packages.
+type MergeRef struct {
+	Table  string
+	Column string
+	ByID   bool
+	// Condition to only re-point some rows, like the queries that target nodes by UUID
+	Where string
+	// Columns unique together with the node, rows the canonical node already has stay with the duplicate
+	Unique []string
+}
+
+// MergeRefs to list every table with records of nodes, re-pointed to the canonical node when merging
+var MergeRefs = []MergeRef{
+	// Results attribution
+	{Table: "osquery_result_data", Column: "uuid"},
+	{Table: "osquery_status_data", Column: "uuid"},
+	{Table: "osquery_query_data", Column: "uuid"},
+	{Table: "ingested_data", Column: "node_id", ByID: true},
+	// Query targets, only the ones by UUID, the type is the uuid target of queries
+	{Table: "distributed_query_targets", Column: "value", Where: "type = 'uuid'"},
+	{Table: "distributed_query_executions", Column: "uuid"},
+	{Table: "node_queries", Column: "node_id", ByID: true, Unique: []string{"name"}},
+	{Table: "node_queries", Column: "uuid"},
+	// Tags
+	{Table: "tagged_nodes", Column: "node_id", ByID: true, Unique: []string{"admin_tag_id"}},
+	{Table: "tag_job_nodes", Column: "node_id", ByID: true},
+	// Attributes and history of the node
+	{Table: "node_attributes", Column: "node_id", ByID: true},
+	{Table: "node_history_ip_addresses", Column: "uuid"},
+	{Table: "node_history_hostnames", Column: "uuid"},
+	{Table: "node_history_localnames", Column: "uuid"},
+	{Table: "node_history_usernames", Column: "uuid"},
+	// Lifecycle events
+	{Table: "node_memberships", Column: "node_id", ByID: true},
+	{Table: "node_memberships", Column: "uuid"},
+	{Table: "node_decommissions", Column: "node_id", ByID: true},
+	{Table: "node_decommissions", Column: "uuid"},
+	{Table: "node_identities", Column: "node_id", ByID: true},
+	{Table: "node_identities", Column: "uuid"},
+	{Table: "node_config_lineages", Column: "uuid"},
+	{Table: "node_certificates", Column: "uuid"},
+	{Table: "schedule_overrides", Column: "uuid"},
+	{Table: "response_actions", Column: "node_uuid"},
+	// Carve sessions
+	{Table: "carved_files", Column: "node_id", ByID: true},
+	{Table: "carved_files", Column: "uuid"},
+	{Table: "recurring_carve_runs", Column: "uuid"},
+}
+
+// MergeThresholds to decide when two nodes are proposed as duplicates
+type MergeThresholds struct {
+	Score            int `json:"score"`
+	HostnameDistance int `json:"hostname_distance"`
+}
+
+// MergeEvidence for one signal of two nodes being the same host, with its weight in the score
+type MergeEvidence struct {
+	Signal string `json:"signal"`
+	Detail string `json:"detail"`
+	Weight int    `json:"weight"`
+}
+
+// NodeMerge for a duplicate node proposed or merged into a canonical node, with the evidence for it
+type NodeMerge struct {
+	gorm.Model
+	EnvironmentID     uint   `gorm:"index"`
+	CanonicalUUID     string `gorm:"index"`
+	CanonicalID       uint
+	CanonicalHostname string
+	DuplicateUUID     string `gorm:"index"`
+	DuplicateID       uint
+	DuplicateHostname string
+	Score             int
+	Evidence          string
+	State             string `gorm:"index"`
+	Proposer          string
+	Reviewer          string
+	ReviewedAt        time.Time
+	ArchiveID         uint
+	SplitBy           string
+	SplitAt           time.Time
+}
+
+// NodeMergeRow for the rows of one table re-pointed by a merge, so the merge can be split back
+type NodeMergeRow struct {
+	gorm.Model
+	MergeID uint `gorm:"index"`
+	Table   string
+	Column  string
+	ByID    bool
+	RowIDs  string
+	Rows    int
+}
+
+// NewMergeThresholds to get the thresholds to propose duplicates, with defaults for invalid values
+func NewMergeThresholds(score, hostnameDistance int) MergeThresholds {
+	th := MergeThresholds{Score: score, HostnameDistance: hostnameDistance}
+	if th.Score <= 0 || th.Score > 100 {
+		th.Score = DefaultMergeScore
+	}
+	if th.HostnameDistance < 0 {
+		th.HostnameDistance = DefaultMergeHostnameDistance
+	}
+	return th
+}
+
+// MergeWindow to get how long merges can be split back from the days in settings, default if not set
+func MergeWindow(days int) time.Duration {
+	if days <= 0 {
+		days = DefaultMergeSplitDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// Evidences to get the signals that proposed the merge
+func (m NodeMerge) Evidences() []MergeEvidence {
+	var evidence []MergeEvidence
+	if err := json.Unmarshal([]byte(m.Evidence), &evidence); err != nil {
+		return []MergeEvidence{}
+	}
+	return evidence
+}
+
+// MergeSummary to describe a merge by its state, for the audit of the environment
+func MergeSummary(m NodeMerge) string {
+	nodes := fmt.Sprintf("node %s (%s) and %s (%s)", m.DuplicateHostname, m.DuplicateUUID, m.CanonicalHostname, m.CanonicalUUID)
+	switch m.State {
+	case MergeMerged:
+		return fmt.Sprintf("node %s (%s) merged into %s (%s), score %d", m.DuplicateHostname, m.DuplicateUUID, m.CanonicalHostname, m.CanonicalUUID, m.Score)
+	case MergeRejected:
+		return nodes + " are not duplicates"
+	case MergeSplit:
+		return fmt.Sprintf("node %s (%s) split back from %s (%s)", m.DuplicateHostname, m.DuplicateUUID, m.CanonicalHostname, m.CanonicalUUID)
+	}
+	return nodes + " proposed as duplicates"
+}
+
+// Splittable to check if a merge can still be split back at a given time
+func (m NodeMerge) Splittable(now time.Time, window time.Duration) bool {
+	return m.State == MergeMerged && now.Sub(m.ReviewedAt) <= window
+}
+
+// NodeLastSeen to get the last time a node contacted for anything
+func NodeLastSeen(node OsqueryNode) time.Time {
+	last := node.CreatedAt
+	for _, t := range []time.Time{node.LastStatus, node.LastResult, node.LastConfig, node.LastQueryRead, node.LastQueryWrite} {
+		if t.After(last) {
+			last = t
+		}
+	}
+	return last
+}
+
+// Helper to get the short hostname in lower case, to compare hostnames with and without domain
+func shortHostname(hostname string) string {
+	h := strings.ToLower(strings.TrimSpace(hostname))
+	if i := strings.Index(h, "."); i > 0 {
+		h = h[:i]
+	}
+	return h
+}
+
+// Helper to get the serial of the hardware, empty for the placeholders of virtual machines and unknown values
+func cleanSerial(serial string) string {
+	s := strings.ToUpper(strings.TrimSpace(serial))
+	switch s {
+	case "", "0", "NONE", "UNKNOWN", "DEFAULT STRING", "TO BE FILLED BY O.E.M.", "SYSTEM SERIAL NUMBER":
+		return ""
+	}
+	return s
+}
+
+// HostnameDistance to get the number of edits to turn one hostname into the other
+func HostnameDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+// ScoreDuplicate to score in percent how likely two nodes are the same host, with the evidence for it. Nodes
+// reporting different hardware are never duplicates, and nodes active at the same time count against it.
+func ScoreDuplicate(a, b OsqueryNode, th MergeThresholds) (int, []MergeEvidence) {
+	evidence := []MergeEvidence{}
+	fa, fb := FingerprintFromEnrollment(a.RawEnrollment), FingerprintFromEnrollment(b.RawEnrollment)
+	if IdentityConflict(fa, fb) {
+		return 0, []MergeEvidence{{Signal: "hardware", Detail: fmt.Sprintf("different hardware UUID %s and %s", fa.HardwareUUID, fb.HardwareUUID)}}
+	}
+	score := 0
+	add := func(signal, detail string, weight int) {
+		score += weight
+		evidence = append(evidence, MergeEvidence{Signal: signal, Detail: detail, Weight: weight})
+	}
+	if fa.HardwareUUID != "" && fa.HardwareUUID == fb.HardwareUUID {
+		add("hardware", "same hardware UUID "+fa.HardwareUUID, mergeWeightHardware)
+	}
+	if sa := cleanSerial(a.HardwareSerial); sa != "" && sa == cleanSerial(b.HardwareSerial) {
+		add("serial", "same hardware serial "+sa, mergeWeightSerial)
+	}
+	if len(fa.MACs) > 0 && len(fb.MACs) > 0 {
+		if overlap := macOverlap(fa.MACs, fb.MACs); overlap > 0 {
+			add("macs", fmt.Sprintf("%d%% of MAC addresses in common", int(overlap*100+0.5)), int(mergeWeightMACs*overlap+0.5))
+		}
+	}
+	ha, hb := shortHostname(a.Hostname), shortHostname(b.Hostname)
+	if ha != "" && hb != "" {
+		if d := HostnameDistance(ha, hb); d <= th.HostnameDistance {
+			detail := "same hostname " + ha
+			if d > 0 {
+				detail = fmt.Sprintf("hostnames %s and %s differ in %d characters", ha, hb, d)
+			}
+			add("hostname", detail, mergeWeightHostname)
+		}
+	}
+	// One record stops when the other starts, like after a reinstall
+	first, second := a, b
+	if second.CreatedAt.Before(first.CreatedAt) {
+		first, second = second, first
+	}
+	if NodeLastSeen(first).Before(second.CreatedAt) {
+		add("activity", fmt.Sprintf("%s last seen %s, before %s enrolled %s", first.UUID, NodeLastSeen(first).Format(time.RFC3339), second.UUID, second.CreatedAt.Format(time.RFC3339)), mergeWeightActivity)
+	} else {
+		add("activity", "both nodes were active at the same time", -mergeWeightOverlap)
+	}
+	if score < 0 {
+		score = 0
+	}
+	if score > 100 {
+		score = 100
+	}
+	return score, evidence
+}
+
+// Helper to get the keys that put two nodes in the same group of candidates, so not every pair is scored
+func mergeKeys(node OsqueryNode) []string {
+	fp := FingerprintFromEnrollment(node.RawEnrollment)
+	var keys []string
+	if fp.HardwareUUID != "" {
+		keys = append(keys, "hardware:"+fp.HardwareUUID)
+	}
+	if s := cleanSerial(node.HardwareSerial); s != "" {
+		keys = append(keys, "serial:"+s)
+	}
+	for _, m := range fp.MACs {
+		keys = append(keys, "mac:"+m)
+	}
+	// Near-identical hostnames share the beginning most of the time
+	if h := shortHostname(node.Hostname); h != "" {
+		if len(h) > 3 {
+			h = h[:3]
+		}
+		keys = append(keys, "hostname:"+h)
+	}
+	return keys
+}
+
+// Helper to get the canonical and the duplicate of two nodes, the one seen last is kept
+func canonicalNode(a, b OsqueryNode) (OsqueryNode, OsqueryNode) {
+	if NodeLastSeen(b).After(NodeLastSeen(a)) {
+		return b, a
+	}
+	return a, b
+}
+
+// Helper to prepare a merge of two nodes with the score and evidence of the duplicate
+func newNodeMerge(canonical, duplicate OsqueryNode, score int, evidence []MergeEvidence, proposer string) NodeMerge {
+	raw, err := json.Marshal(evidence)
+	if err != nil {
+		raw = []byte("[]")
+	}
+	return NodeMerge{
+		EnvironmentID:     canonical.EnvironmentID,
+		CanonicalUUID:     canonical.UUID,
+		CanonicalID:       canonical.ID,
+		CanonicalHostname: canonical.Hostname,
+		DuplicateUUID:     duplicate.UUID,
+		DuplicateID:       duplicate.ID,
+		DuplicateHostname: duplicate.Hostname,
+		Score:             score,
+		Evidence:          string(raw),
+		State:             MergeProposed,
+		Proposer:          proposer,
+	}
+}
+
+// ProposeDuplicates to score the nodes of an environment and propose as duplicates the pairs over the threshold,
+// pairs already proposed, rejected, merged or split are not proposed again. It returns how many were proposed.
+func (n *NodeManager) ProposeDuplicates(envid uint, th MergeThresholds) (int, error) {
+	var nodes []OsqueryNode
+	if err := n.read().Where("environment_id = ?", envid).Find(&nodes).Error; err != nil {
+		return 0, fmt.Errorf("Find %v", err)
+	}
+	var existing []NodeMerge
+	if err := n.read().Where("environment_id = ?", envid).Find(&existing).Error; err != nil {
+		return 0, fmt.Errorf("Find %v", err)
+	}
+	seen := make(map[string]bool, len(existing))
+	for _, m := range existing {
+		seen[m.CanonicalUUID+"/"+m.DuplicateUUID] = true
+		seen[m.DuplicateUUID+"/"+m.CanonicalUUID] = true
+	}
+	groups := make(map[string][]int)
+	for i, node := range nodes {
+		for _, k := range mergeKeys(node) {
+			groups[k] = append(groups[k], i)
+		}
+	}
+	proposed := 0
+	for key, group := range groups {
+		if strings.HasPrefix(key, "hostname:") && len(group) > mergeMaxHostnameGroup {
+			continue
+		}
+		for x := 0; x < len(group); x++ {
+			for y := x + 1; y < len(group); y++ {
+				a, b := nodes[group[x]], nodes[group[y]]
+				if a.UUID == b.UUID || seen[a.UUID+"/"+b.UUID] {
+					continue
+				}
+				seen[a.UUID+"/"+b.UUID] = true
+				seen[b.UUID+"/"+a.UUID] = true
+				score, evidence := ScoreDuplicate(a, b, th)
+				if score < th.Score {
+					continue
+				}
+				canonical, duplicate := canonicalNode(a, b)
+				m := newNodeMerge(canonical, duplicate, score, evidence, MergeJobProposer)
+				if err := n.DB.Create(&m).Error; err != nil {
+					return proposed, fmt.Errorf("Create %v", err)
+				}
+				proposed++
+			}
+		}
+	}
+	return proposed, nil
+}
+
+// ProposeMerge to propose manually one node as duplicate of another, scored with the same signals
+func (n *NodeManager) ProposeMerge(into, from, proposer string, th MergeThresholds) (NodeMerge, error) {
+	if strings.EqualFold(into, from) {
+		return NodeMerge{}, fmt.Errorf("a node can not be merged into itself")
+	}
+	canonical, err := n.GetByUUID(into)
+	if err != nil {
+		return NodeMerge{}, fmt.Errorf("getNodeByUUID %s %v", into, err)
+	}
+	duplicate, err := n.GetByUUID(from)
+	if err != nil {
+		return NodeMerge{}, fmt.Errorf("getNodeByUUID %s %v", from, err)
+	}
+	score, evidence := ScoreDuplicate(canonical, duplicate, th)
+	m := newNodeMerge(canonical, duplicate, score, evidence, proposer)
+	if err := n.DB.Create(&m).Error; err != nil {
+		return m, fmt.Errorf("Create %v", err)
+	}
+	return m, nil
+}
+
+// ManualMerge to merge one node into another right away, with the same machinery as reviewed duplicates
+func (n *NodeManager) ManualMerge(into, from, actor string, th MergeThresholds) (NodeMerge, error) {
+	m, err := n.ProposeMerge(into, from, actor, th)
+	if err != nil {
+		return m, err
+	}
+	return n.ApproveMerge(m.ID, actor)
+}
+
+// GetMerge to get a merge by ID
+func (n *NodeManager) GetMerge(id uint) (NodeMerge, error) {
+	var m NodeMerge
+	if err := n.read().First(&m, id).Error; err != nil {
+		return m, err
+	}
+	return m, nil
+}
+
+// GetMerges to get the merges of an environment by state, all states if empty
+func (n *NodeManager) GetMerges(envid uint, state string) ([]NodeMerge, error) {
+	var ms []NodeMerge
+	query := n.read().Where("environment_id = ?", envid)
+	if state != "" {
+		query = query.Where("state = ?", state)
+	}
+	if err := query.Order("created_at desc").Find(&ms).Error; err != nil {
+		return ms, err
+	}
+	return ms, nil
+}
+
+// RejectMerge to reject a proposed duplicate, the pair is not proposed again
+func (n *NodeManager) RejectMerge(id uint, reviewer string) (NodeMerge, error) {
+	m, err := n.GetMerge(id)
+	if err != nil {
+		return m, err
+	}
+	if m.State != MergeProposed {
+		return m, fmt.Errorf("merge %d is %s and can not be rejected", m.ID, m.State)
+	}
+	m.State = MergeRejected
+	m.Reviewer = reviewer
+	m.ReviewedAt = time.Now()
+	if err := n.DB.Model(&m).Updates(map[string]interface{}{"state": m.State, "reviewer": m.Reviewer, "reviewed_at": m.ReviewedAt}).Error; err != nil {
+		return m, fmt.Errorf("Updates %v", err)
+	}
+	return m, nil
+}
+
+// Helper to join IDs of rows to keep them in the DB
+func joinRowIDs(ids []uint) string {
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = strconv.FormatUint(uint64(id), 10)
+	}
+	return strings.Join(s, ",")
+}
+
+// Helper to split IDs of rows kept in the DB
+func splitRowIDs(raw string) []uint {
+	var ids []uint
+	for _, s := range strings.Split(raw, ",") {
+		if id, err := strconv.ParseUint(strings.TrimSpace(s), 10, 64); err == nil {
+			ids = append(ids, uint(id))
+		}
+	}
+	return ids
+}
+
+// Helper to update one column of rows by ID, in batches
+func updateRows(tx *gorm.DB, table, column string, ids []uint, value interface{}) error {
+	for start := 0; start < len(ids); start += mergeBatch {
+		end := start + mergeBatch
+		if end > len(ids) {
+			end = len(ids)
+		}
+		if err := tx.Table(table).Where("id IN ?", ids[start:end]).Update(column, value).Error; err != nil {
+			return fmt.Errorf("Update %s.%s %v", table, column, err)
+		}
+	}
+	return nil
+}
+
+// Helper to re-point the rows of one table from the duplicate to the canonical node, with the rows that moved
+func repointRef(tx *gorm.DB, ref MergeRef, duplicate, canonical OsqueryNode) (NodeMergeRow, error) {
+	var from, to interface{} = duplicate.UUID, canonical.UUID
+	if ref.ByID {
+		from, to = duplicate.ID, canonical.ID
+	}
+	query := tx.Table(ref.Table).Where(ref.Column+" = ?", from)
+	if ref.Where != "" {
+		query = query.Where(ref.Where)
+	}
+	for _, u := range ref.Unique {
+		query = query.Where(fmt.Sprintf("%s NOT IN (SELECT %s FROM %s WHERE %s = ?)", u, u, ref.Table, ref.Column), to)
+	}
+	var ids []uint
+	if err := query.Pluck("id", &ids).Error; err != nil {
+		return NodeMergeRow{}, fmt.Errorf("Pluck %s.%s %v", ref.Table, ref.Column, err)
+	}
+	row := NodeMergeRow{Table: ref.Table, Column: ref.Column, ByID: ref.ByID, RowIDs: joinRowIDs(ids), Rows: len(ids)}
+	if len(ids) == 0 {
+		return row, nil
+	}
+	return row, updateRows(tx, ref.Table, ref.Column, ids, to)
+}
+
+// ApproveMerge to merge a proposed duplicate into its canonical node. Records of the duplicate in every table of
+// MergeRefs are re-pointed to the canonical node, and the duplicate is archived with a pointer to it.
+func (n *NodeManager) ApproveMerge(id uint, reviewer string) (NodeMerge, error) {
+	m, err := n.GetMerge(id)
+	if err != nil {
+		return m, err
+	}
+	if m.State != MergeProposed {
+		return m, fmt.Errorf("merge %d is %s and can not be approved", m.ID, m.State)
+	}
+	canonical, err := n.GetByUUID(m.CanonicalUUID)
+	if err != nil {
+		return m, fmt.Errorf("getNodeByUUID %s %v", m.CanonicalUUID, err)
+	}
+	duplicate, err := n.GetByUUID(m.DuplicateUUID)
+	if err != nil {
+		return m, fmt.Errorf("getNodeByUUID %s %v", m.DuplicateUUID, err)
+	}
+	err = n.DB.Transaction(func(tx *gorm.DB) error {
+		var rows []NodeMergeRow
+		for _, ref := range MergeRefs {
+			row, err := repointRef(tx, ref, duplicate, canonical)
+			if err != nil {
+				return err
+			}
+			if row.Rows > 0 {
+				row.MergeID = m.ID
+				rows = append(rows, row)
+			}
+		}
+		if len(rows) > 0 {
+			if err := tx.Create(&rows).Error; err != nil {
+				return fmt.Errorf("Create NodeMergeRow %v", err)
+			}
+		}
+		archived := nodeArchiveFromNode(duplicate, mergeTrigger)
+		archived.MergedInto = canonical.UUID
+		if err := tx.Create(&archived).Error; err != nil {
+			return fmt.Errorf("Create %v", err)
+		}
+		if err := tx.Unscoped().Delete(&duplicate).Error; err != nil {
+			return fmt.Errorf("Delete %v", err)
+		}
+		if err := RecordMembership(tx, envMembership(duplicate, duplicate.Environment, MembershipRemoved, reviewer)); err != nil {
+			return err
+		}
+		if err := updatePlatform(tx, duplicate.EnvironmentID, duplicate.Platform, -1); err != nil {
+			return err
+		}
+		m.State = MergeMerged
+		m.Reviewer = reviewer
+		m.ReviewedAt = time.Now()
+		m.ArchiveID = archived.ID
+		return tx.Model(&m).Updates(map[string]interface{}{
+			"state":       m.State,
+			"reviewer":    m.Reviewer,
+			"reviewed_at": m.ReviewedAt,
+			"archive_id":  m.ArchiveID,
+		}).Error
+	})
+	if err != nil {
+		return m, fmt.Errorf("Merge %v", err)
+	}
+	n.invalidatePlatforms()
+	n.invalidateDecommissions()
+	n.invalidateIdentities()
+	return m, nil
+}
+
+// SplitMerge to revert a merge within the window, the duplicate is restored from its archive with its original ID
+// and the rows re-pointed by the merge are pointed back to it
+func (n *NodeManager) SplitMerge(id uint, actor string, window time.Duration) (NodeMerge, error) {
+	m, err := n.GetMerge(id)
+	if err != nil {
+		return m, err
+	}
+	if m.State != MergeMerged {
+		return m, fmt.Errorf("merge %d is %s and can not be split", m.ID, m.State)
+	}
+	if !m.Splittable(time.Now(), window) {
+		return m, fmt.Errorf("merge %d is older than %s and can not be split", m.ID, window)
+	}
+	if n.CheckByUUID(m.DuplicateUUID) {
+		return m, fmt.Errorf("node %s enrolled again after the merge", m.DuplicateUUID)
+	}
+	var archived ArchiveOsqueryNode
+	if err := n.DB.First(&archived, m.ArchiveID).Error; err != nil {
+		return m, fmt.Errorf("archived node %v", err)
+	}
+	var rows []NodeMergeRow
+	if err := n.DB.Where("merge_id = ?", m.ID).Find(&rows).Error; err != nil {
+		return m, fmt.Errorf("Find %v", err)
+	}
+	err = n.DB.Transaction(func(tx *gorm.DB) error {
+		restored := nodeFromArchive(archived)
+		restored.ID = m.DuplicateID
+		if err := tx.Create(&restored).Error; err != nil {
+			return fmt.Errorf("Create %v", err)
+		}
+		for _, row := range rows {
+			var value interface{} = m.DuplicateUUID
+			if row.ByID {
+				value = m.DuplicateID
+			}
+			if err := updateRows(tx, row.Table, row.Column, splitRowIDs(row.RowIDs), value); err != nil {
+				return err
+			}
+		}
+		if err := tx.Delete(&archived).Error; err != nil {
+			return fmt.Errorf("Delete %v", err)
+		}
+		if err := RecordMembership(tx, envMembership(restored, restored.Environment, MembershipAdded, actor)); err != nil {
+			return err
+		}
+		if err := updatePlatform(tx, restored.EnvironmentID, restored.Platform, 1); err != nil {
+			return err
+		}
+		m.State = MergeSplit
+		m.SplitBy = actor
+		m.SplitAt = time.Now()
+		return tx.Model(&m).Updates(map[string]interface{}{"state": m.State, "split_by": m.SplitBy, "split_at": m.SplitAt}).Error
+	})
+	if err != nil {
+		return m, fmt.Errorf("Split %v", err)
+	}
+	n.invalidatePlatforms()
+	n.invalidateDecommissions()
+	n.invalidateIdentities()
+	return m, nil
+}
+
+// StartMergeScoring to propose duplicate nodes periodically, only by the instance holding the leader lock
+func (n *NodeManager) StartMergeScoring(ctx context.Context, leader *backend.LeaderLock, interval time.Duration, envs func() ([]uint, error), thresholds func() MergeThresholds) {
+	if interval <= 0 {
+		interval = DefaultMergeInterval
+	}
+	run := func() {
+		if leader != nil {
+			isLeader, err := leader.Acquire()
+			if err != nil {
+				log.Printf("error acquiring leader lock %v", err)
+				return
+			}
+			if !isLeader {
+				return
+			}
+		}
+		ids, err := envs()
+		if err != nil {
+			log.Printf("error getting environments for duplicate nodes %v", err)
+			return
+		}
+		th := thresholds()
+		for _, envid := range ids {
+			if _, err := n.ProposeDuplicates(envid, th); err != nil {
+				log.Printf("error proposing duplicate nodes in environment %d %v", envid, err)
+			}
+		}
+	}
+	go func() {
+		run()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				run()
+			}
+		}
+	}()
+}
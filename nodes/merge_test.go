@@ -0,0 +1,218 @@
+package nodes
+
+import (
+	"database/sql/driver"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+const (
+	mergeEnrollA = `{"host_details":{"system_info":{"uuid":"hw-1"},"interface_details":[{"mac":"aa:bb:cc:00:00:01"},{"mac":"aa:bb:cc:00:00:02"}]}}`
+	mergeEnrollB = `{"host_details":{"system_info":{"uuid":"HW-1"},"interface_details":[{"mac":"aa:bb:cc:00:00:01"},{"mac":"aa:bb:cc:00:00:03"}]}}`
+	mergeEnrollC = `{"host_details":{"system_info":{"uuid":"hw-2"}}}`
+)
+
+func TestMergeRefsCoverTables(t *testing.T) {
+	covered := make(map[string][]string)
+	for _, ref := range MergeRefs {
+		covered[ref.Table] = append(covered[ref.Table], ref.Column)
+	}
+	assert.Equal(t, map[string][]string{
+		"osquery_result_data":          {"uuid"},
+		"osquery_status_data":          {"uuid"},
+		"osquery_query_data":           {"uuid"},
+		"ingested_data":                {"node_id"},
+		"distributed_query_targets":    {"value"},
+		"distributed_query_executions": {"uuid"},
+		"node_queries":                 {"node_id", "uuid"},
+		"tagged_nodes":                 {"node_id"},
+		"tag_job_nodes":                {"node_id"},
+		"node_attributes":              {"node_id"},
+		"node_history_ip_addresses":    {"uuid"},
+		"node_history_hostnames":       {"uuid"},
+		"node_history_localnames":      {"uuid"},
+		"node_history_usernames":       {"uuid"},
+		"node_memberships":             {"node_id", "uuid"},
+		"node_decommissions":           {"node_id", "uuid"},
+		"node_identities":              {"node_id", "uuid"},
+		"node_config_lineages":         {"uuid"},
+		"node_certificates":            {"uuid"},
+		"schedule_overrides":           {"uuid"},
+		"response_actions":             {"node_uuid"},
+		"carved_files":                 {"node_id", "uuid"},
+		"recurring_carve_runs":         {"uuid"},
+	}, covered)
+}
+
+func TestHostnameDistance(t *testing.T) {
+	assert.Equal(t, 0, HostnameDistance("laptop-jdoe", "laptop-jdoe"))
+	assert.Equal(t, 1, HostnameDistance("laptop-jdoe", "laptop-jdoe2"))
+	assert.Equal(t, 2, HostnameDistance("web01", "web10"))
+	assert.Equal(t, 3, HostnameDistance("", "abc"))
+	assert.Equal(t, "laptop-jdoe", shortHostname(" Laptop-JDoe.corp.example.com"))
+}
+
+func TestScoreDuplicate(t *testing.T) {
+	th := NewMergeThresholds(0, -1)
+	assert.Equal(t, DefaultMergeScore, th.Score)
+	assert.Equal(t, DefaultMergeHostnameDistance, th.HostnameDistance)
+	day := func(d int) time.Time { return time.Date(2024, 7, d, 0, 0, 0, 0, time.UTC) }
+	old := OsqueryNode{Model: gorm.Model{CreatedAt: day(1)}, UUID: "OLD", Hostname: "laptop-jdoe", HardwareSerial: "C02XYZ", RawEnrollment: mergeEnrollA, LastStatus: day(3)}
+	reinstall := OsqueryNode{Model: gorm.Model{CreatedAt: day(5)}, UUID: "NEW", Hostname: "laptop-jdoe2.corp", HardwareSerial: "c02xyz", RawEnrollment: mergeEnrollB, LastStatus: day(9)}
+	score, evidence := ScoreDuplicate(old, reinstall, th)
+	assert.Equal(t, 100, score)
+	var signals []string
+	for _, e := range evidence {
+		signals = append(signals, e.Signal)
+	}
+	assert.Equal(t, []string{"hardware", "serial", "macs", "hostname", "activity"}, signals)
+	assert.Equal(t, 10, evidence[2].Weight)
+	// Order of the nodes does not matter, and the one seen last is canonical
+	reverse, _ := ScoreDuplicate(reinstall, old, th)
+	assert.Equal(t, score, reverse)
+	canonical, duplicate := canonicalNode(old, reinstall)
+	assert.Equal(t, "NEW", canonical.UUID)
+	assert.Equal(t, "OLD", duplicate.UUID)
+	// Same hostname alone, active at the same time, is not a duplicate
+	twin := OsqueryNode{Model: gorm.Model{CreatedAt: day(2)}, UUID: "TWIN", Hostname: "laptop-jdoe", LastStatus: day(4)}
+	score, evidence = ScoreDuplicate(old, twin, th)
+	assert.Equal(t, 0, score)
+	assert.Equal(t, -mergeWeightOverlap, evidence[len(evidence)-1].Weight)
+	// Placeholder serials are not evidence
+	vm1 := OsqueryNode{Model: gorm.Model{CreatedAt: day(1)}, UUID: "VM1", HardwareSerial: "0", LastStatus: day(2)}
+	vm2 := OsqueryNode{Model: gorm.Model{CreatedAt: day(3)}, UUID: "VM2", HardwareSerial: "0", LastStatus: day(4)}
+	score, _ = ScoreDuplicate(vm1, vm2, th)
+	assert.Equal(t, mergeWeightActivity, score)
+	// Different hardware is never a duplicate
+	other := reinstall
+	other.RawEnrollment = mergeEnrollC
+	score, evidence = ScoreDuplicate(old, other, th)
+	assert.Equal(t, 0, score)
+	assert.Len(t, evidence, 1)
+}
+
+func TestMergeSummary(t *testing.T) {
+	m := NodeMerge{CanonicalUUID: "NEW", CanonicalHostname: "laptop-jdoe2", DuplicateUUID: "OLD", DuplicateHostname: "laptop-jdoe", Score: 90, State: MergeMerged}
+	assert.Equal(t, "node laptop-jdoe (OLD) merged into laptop-jdoe2 (NEW), score 90", MergeSummary(m))
+	m.State = MergeRejected
+	assert.Equal(t, "node laptop-jdoe (OLD) and laptop-jdoe2 (NEW) are not duplicates", MergeSummary(m))
+	now := time.Now()
+	m.State = MergeMerged
+	m.ReviewedAt = now.Add(-48 * time.Hour)
+	assert.True(t, m.Splittable(now, MergeWindow(0)))
+	assert.False(t, m.Splittable(now, MergeWindow(1)))
+	assert.Equal(t, []uint{1, 2, 30}, splitRowIDs(joinRowIDs([]uint{1, 2, 30})))
+}
+
+func mergeRow(id uint, state string) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "environment_id", "canonical_uuid", "canonical_id", "duplicate_uuid", "duplicate_id", "state", "reviewed_at", "archive_id"}).
+		AddRow(id, 1, "NEW", 2, "OLD", 1, state, time.Now(), 9)
+}
+
+func mergeNodeRow(id uint, uuid string) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "uuid", "environment", "environment_id"}).AddRow(id, uuid, "dev", 1)
+}
+
+func TestApproveMergeRepointsEveryTable(t *testing.T) {
+	n, mock, done := mockNodes(t)
+	defer done()
+	mock.ExpectQuery(`SELECT \* FROM "node_merges"`).WillReturnRows(mergeRow(5, MergeProposed))
+	mock.ExpectQuery(`SELECT \* FROM "osquery_nodes"`).WithArgs("NEW").WillReturnRows(mergeNodeRow(2, "NEW"))
+	mock.ExpectQuery(`SELECT \* FROM "osquery_nodes"`).WithArgs("OLD").WillReturnRows(mergeNodeRow(1, "OLD"))
+	mock.ExpectBegin()
+	for i, ref := range MergeRefs {
+		var from, to interface{} = "OLD", "NEW"
+		if ref.ByID {
+			from, to = 1, 2
+		}
+		rowID := 100 + i
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT "id" FROM "`+ref.Table+`" WHERE `+ref.Column+` = $1`)).
+			WithArgs(append([]driver.Value{from}, uniqueArgs(ref, to)...)...).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(rowID))
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "`+ref.Table+`" SET "`+ref.Column+`"=$1 WHERE id IN ($2)`)).
+			WithArgs(to, rowID).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectQuery(`INSERT INTO "node_merge_rows"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectQuery(`INSERT INTO "archive_osquery_nodes"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(9))
+	mock.ExpectExec(`DELETE FROM "osquery_nodes"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO "node_memberships"`).
+		WithArgs(1, "OLD", MembershipEnvironment, "dev", MembershipRemoved, "admin", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec(`UPDATE "node_merges"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	m, err := n.ApproveMerge(5, "admin")
+	assert.NoError(t, err)
+	assert.Equal(t, MergeMerged, m.State)
+	assert.Equal(t, uint(9), m.ArchiveID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Helper to get the arguments of the conditions of a reference besides the node
+func uniqueArgs(ref MergeRef, to interface{}) []driver.Value {
+	var args []driver.Value
+	for range ref.Unique {
+		args = append(args, to)
+	}
+	return args
+}
+
+func TestApproveMergeRollsBack(t *testing.T) {
+	n, mock, done := mockNodes(t)
+	defer done()
+	// Nothing is re-pointed if one table fails
+	mock.ExpectQuery(`SELECT \* FROM "node_merges"`).WillReturnRows(mergeRow(5, MergeProposed))
+	mock.ExpectQuery(`SELECT \* FROM "osquery_nodes"`).WillReturnRows(mergeNodeRow(2, "NEW"))
+	mock.ExpectQuery(`SELECT \* FROM "osquery_nodes"`).WillReturnRows(mergeNodeRow(1, "OLD"))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT "id" FROM "osquery_result_data"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectExec(`UPDATE "osquery_result_data"`).WillReturnError(assert.AnError)
+	mock.ExpectRollback()
+	_, err := n.ApproveMerge(5, "admin")
+	assert.Error(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	// Only proposed merges are approved
+	mock.ExpectQuery(`SELECT \* FROM "node_merges"`).WillReturnRows(mergeRow(5, MergeRejected))
+	_, err = n.ApproveMerge(5, "admin")
+	assert.EqualError(t, err, "merge 5 is rejected and can not be approved")
+}
+
+func TestSplitMergeRepointsBack(t *testing.T) {
+	n, mock, done := mockNodes(t)
+	defer done()
+	mock.ExpectQuery(`SELECT \* FROM "node_merges"`).WillReturnRows(mergeRow(5, MergeMerged))
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "osquery_nodes"`).WithArgs("OLD").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(`SELECT \* FROM "archive_osquery_nodes"`).WillReturnRows(sqlmock.NewRows([]string{"id", "uuid", "environment", "environment_id", "trigger", "merged_into"}).AddRow(9, "OLD", "dev", 1, mergeTrigger, "NEW"))
+	rows := sqlmock.NewRows([]string{"id", "merge_id", "table", "column", "by_id", "row_ids", "rows"})
+	for i, ref := range MergeRefs {
+		rows.AddRow(i+1, 5, ref.Table, ref.Column, ref.ByID, "100,101", 2)
+	}
+	mock.ExpectQuery(`SELECT \* FROM "node_merge_rows"`).WillReturnRows(rows)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "osquery_nodes"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	for _, ref := range MergeRefs {
+		var to interface{} = "OLD"
+		if ref.ByID {
+			to = 1
+		}
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "`+ref.Table+`" SET "`+ref.Column+`"=$1 WHERE id IN ($2,$3)`)).
+			WithArgs(to, 100, 101).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+	}
+	mock.ExpectExec(`UPDATE "archive_osquery_nodes" SET "deleted_at"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO "node_memberships"`).
+		WithArgs(1, "OLD", MembershipEnvironment, "dev", MembershipAdded, "admin", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec(`UPDATE "node_merges"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	m, err := n.SplitMerge(5, "admin", MergeWindow(DefaultMergeSplitDays))
+	assert.NoError(t, err)
+	assert.Equal(t, MergeSplit, m.State)
+	assert.Equal(t, "admin", m.SplitBy)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
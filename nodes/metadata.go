@@ -1,6 +1,9 @@
 package nodes
 
-// NodeMetadata to hold metadata for a node
+import "time"
+
+// NodeMetadata to hold metadata for a node. AgentTime is the newest time reported by
+// the node in its logs and Received is when the server got them.
 type NodeMetadata struct {
 	IPAddress       string
 	Username        string
@@ -13,6 +16,8 @@ type NodeMetadata struct {
 	Platform        string
 	PlatformVersion string
 	BytesReceived   int
+	AgentTime       time.Time
+	Received        time.Time
 }
 
 // GetMetadata to extract the metadata struct from a node
@@ -0,0 +1,201 @@
+package nodes
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// DefaultNamingBatch is the number of nodes loaded at once when names are normalized again
+	DefaultNamingBatch int = 500
+	// maxNamingChanges is how many changes of names are returned in a report
+	maxNamingChanges int = 100
+)
+
+// Valid domain suffixes to strip from the names of nodes
+var namingDomainRegex = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)*$`)
+
+// NamingPolicy to normalize the names of nodes of an environment, the reported values are kept as they are
+// in hostname and localname and the normalized one is the display name
+type NamingPolicy struct {
+	Lowercase    bool     `json:"lowercase"`
+	StripDomains []string `json:"strip_domains"`
+	PreferFQDN   bool     `json:"prefer_fqdn"`
+}
+
+// NamingChange to report the display name of a node changed by a policy
+type NamingChange struct {
+	UUID      string `json:"uuid"`
+	Hostname  string `json:"hostname"`
+	Localname string `json:"localname"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+}
+
+// NamingReport to return the result of normalizing the names of the nodes of an environment again.
+// Collisions are display names shared by more than one node, they are only reported and never merged.
+type NamingReport struct {
+	DryRun     bool                `json:"dry_run"`
+	Nodes      int                 `json:"nodes"`
+	Changed    int                 `json:"changed"`
+	Changes    []NamingChange      `json:"changes"`
+	Collisions map[string][]string `json:"collisions"`
+}
+
+// ParseNamingDomains to split a comma separated list of domain suffixes, lowercase and without the leading dot
+func ParseNamingDomains(list string) ([]string, error) {
+	var domains []string
+	for _, d := range strings.Split(list, ",") {
+		d = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(d)), ".")
+		if d == "" {
+			continue
+		}
+		if !namingDomainRegex.MatchString(d) {
+			return nil, fmt.Errorf("invalid domain %s", d)
+		}
+		domains = append(domains, d)
+	}
+	return domains, nil
+}
+
+// NewNamingPolicy to prepare the naming policy of an environment, invalid domains are ignored
+func NewNamingPolicy(lowercase bool, domains string, preferFQDN bool) NamingPolicy {
+	policy := NamingPolicy{Lowercase: lowercase, PreferFQDN: preferFQDN}
+	for _, d := range strings.Split(domains, ",") {
+		if parsed, err := ParseNamingDomains(d); err == nil {
+			policy.StripDomains = append(policy.StripDomains, parsed...)
+		}
+	}
+	return policy
+}
+
+// DisplayName to get the normalized name of a node from the reported hostname, the FQDN from the host
+// details, and localname. The localname is used unless the FQDN is preferred or there is no localname.
+func (p NamingPolicy) DisplayName(hostname, localname string) string {
+	name := strings.TrimSpace(localname)
+	if fqdn := strings.TrimSpace(hostname); fqdn != "" && (p.PreferFQDN || name == "") {
+		name = fqdn
+	}
+	name = strings.TrimSuffix(name, ".")
+	lower := strings.ToLower(name)
+	for _, d := range p.StripDomains {
+		if strings.HasSuffix(lower, "."+d) {
+			name = name[:len(name)-len(d)-1]
+			break
+		}
+	}
+	if p.Lowercase {
+		name = strings.ToLower(name)
+	}
+	return name
+}
+
+// Helper to get the naming policy of the environment of a node, no normalization without a policy
+func (n *NodeManager) namingPolicy(environment string) NamingPolicy {
+	if n.Naming == nil {
+		return NamingPolicy{}
+	}
+	return n.Naming(environment)
+}
+
+// Helper to refresh the reported names of a node, and its display name, when they change
+func (n *NodeManager) refreshNames(node OsqueryNode, hostname, localname string) error {
+	if hostname == "" {
+		hostname = node.Hostname
+	}
+	if localname == "" {
+		localname = node.Localname
+	}
+	if hostname == node.Hostname && localname == node.Localname && node.DisplayName != "" {
+		return nil
+	}
+	name := n.namingPolicy(node.Environment).DisplayName(hostname, localname)
+	if hostname == node.Hostname && localname == node.Localname && name == node.DisplayName {
+		return nil
+	}
+	updates := map[string]interface{}{
+		"hostname":     hostname,
+		"localname":    localname,
+		"display_name": name,
+	}
+	if err := n.DB.Model(&node).Updates(updates).Error; err != nil {
+		return fmt.Errorf("Updates %v", err)
+	}
+	return nil
+}
+
+// Normalize to apply a naming policy to the display names of all the nodes of an environment, in batches.
+// With dry run nothing is changed, and the report has how many names would change and the collisions.
+func (n *NodeManager) Normalize(envID uint, policy NamingPolicy, dryRun bool, batch int) (NamingReport, error) {
+	report := NamingReport{DryRun: dryRun, Changes: []NamingChange{}, Collisions: make(map[string][]string)}
+	if batch <= 0 {
+		batch = DefaultNamingBatch
+	}
+	names := make(map[string][]string)
+	var found []OsqueryNode
+	err := n.DB.Select("id", "uuid", "hostname", "localname", "display_name").Where("environment_id = ?", envID).
+		FindInBatches(&found, batch, func(tx *gorm.DB, _ int) error {
+			for _, node := range found {
+				report.Nodes++
+				name := policy.DisplayName(node.Hostname, node.Localname)
+				names[name] = append(names[name], node.UUID)
+				if name == node.DisplayName {
+					continue
+				}
+				report.Changed++
+				if len(report.Changes) < maxNamingChanges {
+					report.Changes = append(report.Changes, NamingChange{
+						UUID:      node.UUID,
+						Hostname:  node.Hostname,
+						Localname: node.Localname,
+						From:      node.DisplayName,
+						To:        name,
+					})
+				}
+				if dryRun {
+					continue
+				}
+				if err := n.DB.Model(&OsqueryNode{}).Where("id = ?", node.ID).Update("display_name", name).Error; err != nil {
+					return fmt.Errorf("Update %v", err)
+				}
+			}
+			return nil
+		}).Error
+	if err != nil {
+		return report, fmt.Errorf("FindInBatches %v", err)
+	}
+	for name, uuids := range names {
+		if name != "" && len(uuids) > 1 {
+			sort.Strings(uuids)
+			report.Collisions[name] = uuids
+		}
+	}
+	return report, nil
+}
+
+// NameCollisions to get the display names shared by more than one node of an environment, with their UUIDs
+func (n *NodeManager) NameCollisions(envID uint) (map[string][]string, error) {
+	collisions := make(map[string][]string)
+	var names []string
+	err := n.read().Model(&OsqueryNode{}).Where("environment_id = ? AND display_name <> ''", envID).
+		Group("display_name").Having("COUNT(*) > 1").Pluck("display_name", &names).Error
+	if err != nil {
+		return collisions, fmt.Errorf("Pluck %v", err)
+	}
+	if len(names) == 0 {
+		return collisions, nil
+	}
+	var found []OsqueryNode
+	if err := n.read().Select("uuid", "display_name").Where("environment_id = ? AND display_name IN ?", envID, names).
+		Order("uuid").Find(&found).Error; err != nil {
+		return collisions, fmt.Errorf("Find %v", err)
+	}
+	for _, node := range found {
+		collisions[node.DisplayName] = append(collisions[node.DisplayName], node.UUID)
+	}
+	return collisions, nil
+}
@@ -0,0 +1,37 @@
+package nodes
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseNamingDomains(t *testing.T) {
+	domains, err := ParseNamingDomains(" .Corp.Example.com, lan,, ")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"corp.example.com", "lan"}, domains)
+	_, err = ParseNamingDomains("corp.example.com,bad_domain")
+	assert.Error(t, err)
+	domains, err = ParseNamingDomains("")
+	assert.NoError(t, err)
+	assert.Empty(t, domains)
+}
+
+func TestNewNamingPolicy(t *testing.T) {
+	policy := NewNamingPolicy(true, "corp.example.com,bad_domain", false)
+	assert.True(t, policy.Lowercase)
+	assert.Equal(t, []string{"corp.example.com"}, policy.StripDomains)
+}
+
+func TestDisplayName(t *testing.T) {
+	// Without a policy the localname is used, the hostname when there is no localname
+	assert.Equal(t, "WEB-01", NamingPolicy{}.DisplayName("WEB-01.corp.example.com", "WEB-01"))
+	assert.Equal(t, "WEB-01.corp.example.com", NamingPolicy{}.DisplayName("WEB-01.corp.example.com", ""))
+	policy := NewNamingPolicy(true, "corp.example.com", true)
+	assert.Equal(t, "web-01", policy.DisplayName("WEB-01.Corp.Example.com.", "WEB-01"))
+	assert.Equal(t, "web-01.lab.example.com", policy.DisplayName("WEB-01.lab.example.com", "web-01"))
+	// Only suffixes of whole labels are stripped
+	assert.Equal(t, "web-01.notcorp.example.com", NewNamingPolicy(false, "corp.example.com", true).DisplayName("web-01.notcorp.example.com", ""))
+	// The case is kept without lowercase
+	assert.Equal(t, "WEB-01", NewNamingPolicy(false, "corp.example.com", true).DisplayName("WEB-01.CORP.EXAMPLE.COM", ""))
+}
@@ -1,11 +1,13 @@
 package nodes
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"strings"
 	"time"
 
+	"github.com/jmpsec/osctrl/backend"
 	"gorm.io/gorm"
 )
 
@@ -19,6 +21,7 @@ type OsqueryNode struct {
 	OsqueryVersion  string
 	Hostname        string
 	Localname       string
+	DisplayName     string
 	IPAddress       string
 	Username        string
 	OsqueryUser     string
@@ -30,14 +33,19 @@ type OsqueryNode struct {
 	ConfigHash      string
 	BytesReceived   int
 	RawEnrollment   string
+	EnrollMetadata  string
 	LastStatus      time.Time
 	LastResult      time.Time
 	LastConfig      time.Time
 	LastQueryRead   time.Time
 	LastQueryWrite  time.Time
+	AgentTime       time.Time
+	ClockSkew       int64
 	UserID          uint
 	EnvironmentID   uint
 	ExtraData       string
+	CheckinHistory  string                 `json:"-"`
+	NetworkHistory  []NodeHistoryIPAddress `gorm:"-" json:",omitempty"`
 }
 
 // ArchiveOsqueryNode as abstraction of an archived node
@@ -46,11 +54,13 @@ type ArchiveOsqueryNode struct {
 	NodeKey         string `gorm:"index"`
 	UUID            string `gorm:"index"`
 	Trigger         string
+	MergedInto      string `gorm:"index"`
 	Platform        string
 	PlatformVersion string
 	OsqueryVersion  string
 	Hostname        string
 	Localname       string
+	DisplayName     string
 	IPAddress       string
 	Username        string
 	OsqueryUser     string
@@ -62,11 +72,14 @@ type ArchiveOsqueryNode struct {
 	DaemonHash      string
 	BytesReceived   int
 	RawEnrollment   string
+	EnrollMetadata  string
 	LastStatus      time.Time
 	LastResult      time.Time
 	LastConfig      time.Time
 	LastQueryRead   time.Time
 	LastQueryWrite  time.Time
+	AgentTime       time.Time
+	ClockSkew       int64
 	UserID          uint
 	EnvironmentID   uint
 	ExtraData       string
@@ -81,13 +94,29 @@ type StatsData struct {
 
 // NodeManager to handle all nodes of the system
 type NodeManager struct {
-	DB *gorm.DB
+	DB           *gorm.DB
+	Reader       func() *gorm.DB
+	MaxIPHistory int
+	GeoIP        *GeoIP
+	geoQueue     chan NodeHistoryIPAddress
+	platforms    platformsCache
+	// Time for decommissioned nodes to stop contacting before they are archived
+	DecommissionGrace time.Duration
+	decommissions     decommissionCache
+	// Nodes enrolled in quarantine, cached the same way as decommissioned nodes
+	quarantines decommissionCache
+	// Nodes with identity mismatch, cached the same way as decommissioned nodes
+	identities decommissionCache
+	// Paused nodes, cached the same way as decommissioned nodes
+	pauses decommissionCache
+	// Naming policy of an environment by name, to normalize the display names of nodes
+	Naming func(environment string) NamingPolicy
 }
 
 // CreateNodes to initialize the nodes struct and its tables
 func CreateNodes(backend *gorm.DB) *NodeManager {
 	var n *NodeManager
-	n = &NodeManager{DB: backend}
+	n = &NodeManager{DB: backend, MaxIPHistory: DefaultMaxIPHistory}
 	// table osquery_nodes
 	if err := backend.AutoMigrate(&OsqueryNode{}); err != nil {
 		log.Fatalf("Failed to AutoMigrate table (osquery_nodes): %v", err)
@@ -96,6 +125,22 @@ func CreateNodes(backend *gorm.DB) *NodeManager {
 	if err := backend.AutoMigrate(&ArchiveOsqueryNode{}); err != nil {
 		log.Fatalf("Failed to AutoMigrate table (archive_osquery_nodes): %v", err)
 	}
+	// table env_platforms
+	rebuildPlatforms, err := migratePlatforms(backend)
+	if err != nil {
+		log.Fatalf("Failed to migrate table (env_platforms): %v", err)
+	}
+	if err := backend.AutoMigrate(&EnvPlatform{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (env_platforms): %v", err)
+	}
+	// table node_decommissions
+	if err := backend.AutoMigrate(&NodeDecommission{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (node_decommissions): %v", err)
+	}
+	// table enroll_rejections
+	if err := backend.AutoMigrate(&EnrollRejection{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (enroll_rejections): %v", err)
+	}
 	// table node_history_ipaddress
 	if err := backend.AutoMigrate(&NodeHistoryIPAddress{}); err != nil {
 		log.Fatalf("Failed to AutoMigrate table (node_history_ipaddress): %v", err)
@@ -112,9 +157,60 @@ func CreateNodes(backend *gorm.DB) *NodeManager {
 	if err := backend.AutoMigrate(&NodeHistoryUsername{}); err != nil {
 		log.Fatalf("Failed to AutoMigrate table (node_history_username): %v", err)
 	}
+	// table node_attributes
+	if err := backend.AutoMigrate(&NodeAttribute{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (node_attributes): %v", err)
+	}
+	// table node_count_snapshots
+	if err := backend.AutoMigrate(&NodeCountSnapshot{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (node_count_snapshots): %v", err)
+	}
+	// table node_identities
+	if err := backend.AutoMigrate(&NodeIdentity{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (node_identities): %v", err)
+	}
+	// table node_memberships
+	if err := backend.AutoMigrate(&NodeMembership{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (node_memberships): %v", err)
+	}
+	// table enroll_events
+	if err := backend.AutoMigrate(&EnrollEvent{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (enroll_events): %v", err)
+	}
+	// table enroll_loops
+	if err := backend.AutoMigrate(&EnrollLoop{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (enroll_loops): %v", err)
+	}
+	// table node_merges
+	if err := backend.AutoMigrate(&NodeMerge{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (node_merges): %v", err)
+	}
+	// table node_merge_rows
+	if err := backend.AutoMigrate(&NodeMergeRow{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (node_merge_rows): %v", err)
+	}
+	// table node_pauses
+	if err := backend.AutoMigrate(&NodePause{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (node_pauses): %v", err)
+	}
+	// indexes for searches across environments
+	createSearchIndexes(backend)
+	if rebuildPlatforms {
+		if err := n.RefreshPlatforms(); err != nil {
+			log.Printf("error rebuilding platforms %v", err)
+		}
+	}
 	return n
 }
 
+// Helper to get the connection for reads that tolerate replication lag, DB if there is no Reader
+func (n *NodeManager) read() *gorm.DB {
+	if n.Reader != nil {
+		return n.Reader()
+	}
+	return n.DB
+}
+
 // CheckByKey to check if node exists by node_key
 // node_key is expected lowercase
 func (n *NodeManager) CheckByKey(nodeKey string) bool {
@@ -157,8 +253,16 @@ func (n *NodeManager) CheckByHost(host string) bool {
 // GetByKey to retrieve full node object from DB, by node_key
 // node_key is expected lowercase
 func (n *NodeManager) GetByKey(nodekey string) (OsqueryNode, error) {
+	return n.GetByKeyCtx(context.Background(), nodekey)
+}
+
+// GetByKeyCtx to retrieve full node object from DB, by node_key, aborting if ctx is cancelled
+func (n *NodeManager) GetByKeyCtx(ctx context.Context, nodekey string) (OsqueryNode, error) {
 	var node OsqueryNode
-	if err := n.DB.Where("node_key = ?", strings.ToLower(nodekey)).First(&node).Error; err != nil {
+	err := backend.Retry(ctx, "nodes.GetByKey", func() error {
+		return n.DB.WithContext(ctx).Where("node_key = ?", strings.ToLower(nodekey)).First(&node).Error
+	})
+	if err != nil {
 		return node, err
 	}
 	return node, nil
@@ -167,13 +271,21 @@ func (n *NodeManager) GetByKey(nodekey string) (OsqueryNode, error) {
 // GetByIdentifier to retrieve full node object from DB, by uuid or hostname or localname
 // UUID is expected uppercase
 func (n *NodeManager) GetByIdentifier(identifier string) (OsqueryNode, error) {
+	return n.GetByIdentifierCtx(context.Background(), identifier)
+}
+
+// GetByIdentifierCtx to retrieve full node object from DB, by uuid or hostname or localname, aborting if ctx is cancelled
+func (n *NodeManager) GetByIdentifierCtx(ctx context.Context, identifier string) (OsqueryNode, error) {
 	var node OsqueryNode
-	if err := n.DB.Where(
-		"uuid = ? OR hostname = ? OR localname = ?",
-		strings.ToUpper(identifier),
-		identifier,
-		identifier,
-	).First(&node).Error; err != nil {
+	err := backend.Retry(ctx, "nodes.GetByIdentifier", func() error {
+		return n.DB.WithContext(ctx).Where(
+			"uuid = ? OR hostname = ? OR localname = ?",
+			strings.ToUpper(identifier),
+			identifier,
+			identifier,
+		).First(&node).Error
+	})
+	if err != nil {
 		return node, err
 	}
 	return node, nil
@@ -201,6 +313,11 @@ func (n *NodeManager) GetByUUIDEnv(uuid string, envid uint) (OsqueryNode, error)
 
 // GetBySelector to retrieve target nodes by selector
 func (n *NodeManager) GetBySelector(stype, selector, target string, hours int64) ([]OsqueryNode, error) {
+	return n.GetBySelectorCtx(context.Background(), stype, selector, target, hours)
+}
+
+// GetBySelectorCtx to retrieve target nodes by selector, aborting if ctx is cancelled
+func (n *NodeManager) GetBySelectorCtx(ctx context.Context, stype, selector, target string, hours int64) ([]OsqueryNode, error) {
 	var nodes []OsqueryNode
 	var s string
 	switch stype {
@@ -211,17 +328,17 @@ func (n *NodeManager) GetBySelector(stype, selector, target string, hours int64)
 	}
 	switch target {
 	case "all":
-		if err := n.DB.Where(s+" = ?", selector).Find(&nodes).Error; err != nil {
+		if err := n.read().WithContext(ctx).Where(s+" = ?", selector).Find(&nodes).Error; err != nil {
 			return nodes, err
 		}
 	case "active":
 		//if err := n.DB.Where(s+" = ?", selector).Where("updated_at > ?", time.Now().AddDate(0, 0, -3)).Find(&nodes).Error; err != nil {
-		if err := n.DB.Where(s+" = ?", selector).Where("updated_at > ?", time.Now().Add(time.Duration(hours)*time.Hour)).Find(&nodes).Error; err != nil {
+		if err := n.read().WithContext(ctx).Where(s+" = ?", selector).Where("updated_at > ?", time.Now().Add(time.Duration(hours)*time.Hour)).Find(&nodes).Error; err != nil {
 			return nodes, err
 		}
 	case "inactive":
 		//if err := n.DB.Where(s+" = ?", selector).Where("updated_at < ?", time.Now().AddDate(0, 0, -3)).Find(&nodes).Error; err != nil {
-		if err := n.DB.Where(s+" = ?", selector).Where("updated_at < ?", time.Now().Add(time.Duration(hours)*time.Hour)).Find(&nodes).Error; err != nil {
+		if err := n.read().WithContext(ctx).Where(s+" = ?", selector).Where("updated_at < ?", time.Now().Add(time.Duration(hours)*time.Hour)).Find(&nodes).Error; err != nil {
 			return nodes, err
 		}
 	}
@@ -233,17 +350,17 @@ func (n *NodeManager) Gets(target string, hours int64) ([]OsqueryNode, error) {
 	var nodes []OsqueryNode
 	switch target {
 	case "all":
-		if err := n.DB.Find(&nodes).Error; err != nil {
+		if err := n.read().Find(&nodes).Error; err != nil {
 			return nodes, err
 		}
 	case "active":
 		//if err := n.DB.Where("updated_at > ?", time.Now().AddDate(0, 0, -3)).Find(&nodes).Error; err != nil {
-		if err := n.DB.Where("updated_at > ?", time.Now().Add(time.Duration(hours)*time.Hour)).Find(&nodes).Error; err != nil {
+		if err := n.read().Where("updated_at > ?", time.Now().Add(time.Duration(hours)*time.Hour)).Find(&nodes).Error; err != nil {
 			return nodes, err
 		}
 	case "inactive":
 		//if err := n.DB.Where("updated_at < ?", time.Now().AddDate(0, 0, -3)).Find(&nodes).Error; err != nil {
-		if err := n.DB.Where("updated_at < ?", time.Now().Add(time.Duration(hours)*time.Hour)).Find(&nodes).Error; err != nil {
+		if err := n.read().Where("updated_at < ?", time.Now().Add(time.Duration(hours)*time.Hour)).Find(&nodes).Error; err != nil {
 			return nodes, err
 		}
 	}
@@ -255,37 +372,27 @@ func (n *NodeManager) GetByEnv(environment, target string, hours int64) ([]Osque
 	return n.GetBySelector("environment", environment, target, hours)
 }
 
+// GetByEnvCtx to retrieve target nodes by environment, aborting if ctx is cancelled
+func (n *NodeManager) GetByEnvCtx(ctx context.Context, environment, target string, hours int64) ([]OsqueryNode, error) {
+	return n.GetBySelectorCtx(ctx, "environment", environment, target, hours)
+}
+
 // GetByPlatform to retrieve target nodes by platform
 func (n *NodeManager) GetByPlatform(platform, target string, hours int64) ([]OsqueryNode, error) {
 	return n.GetBySelector("platform", platform, target, hours)
 }
 
-// GetAllPlatforms to get all different platform with nodes in them
-func (n *NodeManager) GetAllPlatforms() ([]string, error) {
-	var platforms []string
-	var platform string
-	rows, err := n.DB.Table("osquery_nodes").Select("DISTINCT(platform)").Rows()
-	if err != nil {
-		return platforms, nil
-	}
-	for rows.Next() {
-		_ = rows.Scan(&platform)
-		platforms = append(platforms, platform)
-	}
-	return platforms, nil
-}
-
 // GetStatsByEnv to populate table stats about nodes by environment. Active machine is < 3 days
 func (n *NodeManager) GetStatsByEnv(environment string, hours int64) (StatsData, error) {
 	var stats StatsData
-	if err := n.DB.Model(&OsqueryNode{}).Where("environment = ?", environment).Count(&stats.Total).Error; err != nil {
+	if err := n.read().Model(&OsqueryNode{}).Where("environment = ?", environment).Count(&stats.Total).Error; err != nil {
 		return stats, err
 	}
 	tHours := time.Now().Add(time.Duration(hours) * time.Hour)
-	if err := n.DB.Model(&OsqueryNode{}).Where("environment = ?", environment).Where("updated_at > ?", tHours).Count(&stats.Active).Error; err != nil {
+	if err := n.read().Model(&OsqueryNode{}).Where("environment = ?", environment).Where("updated_at > ?", tHours).Count(&stats.Active).Error; err != nil {
 		return stats, err
 	}
-	if err := n.DB.Model(&OsqueryNode{}).Where("environment = ?", environment).Where("updated_at < ?", tHours).Count(&stats.Inactive).Error; err != nil {
+	if err := n.read().Model(&OsqueryNode{}).Where("environment = ?", environment).Where("updated_at < ?", tHours).Count(&stats.Inactive).Error; err != nil {
 		return stats, err
 	}
 	return stats, nil
@@ -294,14 +401,14 @@ func (n *NodeManager) GetStatsByEnv(environment string, hours int64) (StatsData,
 // GetStatsByPlatform to populate table stats about nodes by platform. Active machine is < 3 days
 func (n *NodeManager) GetStatsByPlatform(platform string, hours int64) (StatsData, error) {
 	var stats StatsData
-	if err := n.DB.Model(&OsqueryNode{}).Where("platform = ?", platform).Count(&stats.Total).Error; err != nil {
+	if err := n.read().Model(&OsqueryNode{}).Where("platform = ?", platform).Count(&stats.Total).Error; err != nil {
 		return stats, err
 	}
 	tHours := time.Now().Add(time.Duration(hours) * time.Hour)
-	if err := n.DB.Model(&OsqueryNode{}).Where("platform = ?", platform).Where("updated_at > ?", tHours).Count(&stats.Active).Error; err != nil {
+	if err := n.read().Model(&OsqueryNode{}).Where("platform = ?", platform).Where("updated_at > ?", tHours).Count(&stats.Active).Error; err != nil {
 		return stats, err
 	}
-	if err := n.DB.Model(&OsqueryNode{}).Where("platform = ?", platform).Where("updated_at < ?", tHours).Count(&stats.Inactive).Error; err != nil {
+	if err := n.read().Model(&OsqueryNode{}).Where("platform = ?", platform).Where("updated_at < ?", tHours).Count(&stats.Inactive).Error; err != nil {
 		return stats, err
 	}
 	return stats, nil
@@ -326,6 +433,10 @@ func (n *NodeManager) UpdateMetadataByUUID(uuid string, metadata NodeMetadata) e
 	if err := n.RecordLocalname(metadata.Localname, node); err != nil {
 		return fmt.Errorf("RecordLocalname %v", err)
 	}
+	// Reported names and display name, if different
+	if err := n.refreshNames(node, metadata.Hostname, metadata.Localname); err != nil {
+		return fmt.Errorf("refreshNames %v", err)
+	}
 	// Record IP address
 	if err := n.RecordIPAddress(metadata.IPAddress, node); err != nil {
 		return fmt.Errorf("RecordIPAddress %v", err)
@@ -336,14 +447,30 @@ func (n *NodeManager) UpdateMetadataByUUID(uuid string, metadata NodeMetadata) e
 			return fmt.Errorf("MetadataRefresh %v", err)
 		}
 	}
+	// Clock of the node, only when logs reported a time
+	if !metadata.AgentTime.IsZero() {
+		if err := n.RecordAgentTime(node, metadata.AgentTime, metadata.Received); err != nil {
+			return fmt.Errorf("RecordAgentTime %v", err)
+		}
+	}
 	return nil
 }
 
 // Create to insert new osquery node generating new node_key
 func (n *NodeManager) Create(node *OsqueryNode) error {
-	if err := n.DB.Create(&node).Error; err != nil {
+	err := n.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&node).Error; err != nil {
+			return err
+		}
+		if err := RecordMembership(tx, envMembership(*node, node.Environment, MembershipAdded, MembershipEnrollActor)); err != nil {
+			return err
+		}
+		return updatePlatform(tx, node.EnvironmentID, node.Platform, 1)
+	})
+	if err != nil {
 		return fmt.Errorf("Create %v", err)
 	}
+	n.invalidatePlatforms()
 	h := NodeHistoryHostname{
 		UUID:     node.UUID,
 		Hostname: node.Hostname,
@@ -358,13 +485,8 @@ func (n *NodeManager) Create(node *OsqueryNode) error {
 	if err := n.NewHistoryLocalname(l); err != nil {
 		return fmt.Errorf("newNodeHistoryLocalname %v", err)
 	}
-	i := NodeHistoryIPAddress{
-		UUID:      node.UUID,
-		IPAddress: node.IPAddress,
-		Count:     1,
-	}
-	if err := n.NewHistoryIPAddress(i); err != nil {
-		return fmt.Errorf("newNodeHistoryIPAddress %v", err)
+	if err := n.RecordIPAddress(node.IPAddress, *node); err != nil {
+		return fmt.Errorf("recordIPAddress %v", err)
 	}
 	u := NodeHistoryUsername{
 		UUID:     node.UUID,
@@ -403,25 +525,76 @@ func (n *NodeManager) UpdateByUUID(data OsqueryNode, uuid string) error {
 	if err != nil {
 		return fmt.Errorf("getNodeByUUID %v", err)
 	}
-	if err := n.DB.Model(&node).Updates(data).Error; err != nil {
+	// Platform or environment may change when re-enrolling, updates overwrite node so it is kept as it was
+	before := node
+	envid, platform := node.EnvironmentID, node.Platform
+	if data.EnvironmentID != 0 {
+		envid = data.EnvironmentID
+	}
+	if data.Platform != "" {
+		platform = data.Platform
+	}
+	err = n.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&node).Updates(data).Error; err != nil {
+			return err
+		}
+		// Nodes moving to another environment leave the one they were in
+		if data.Environment != "" && data.Environment != before.Environment {
+			if err := RecordMembership(tx,
+				envMembership(before, before.Environment, MembershipRemoved, MembershipEnrollActor),
+				envMembership(before, data.Environment, MembershipAdded, MembershipEnrollActor)); err != nil {
+				return err
+			}
+		}
+		if envid == before.EnvironmentID && platform == before.Platform {
+			return nil
+		}
+		if err := updatePlatform(tx, before.EnvironmentID, before.Platform, -1); err != nil {
+			return err
+		}
+		return updatePlatform(tx, envid, platform, 1)
+	})
+	if err != nil {
 		return fmt.Errorf("Updates %v", err)
 	}
+	n.invalidatePlatforms()
 	return nil
 }
 
-// ArchiveDeleteByUUID to archive and delete an existing node record by UUID
-func (n *NodeManager) ArchiveDeleteByUUID(uuid string) error {
+// ArchiveDeleteByUUID to archive and delete an existing node record by UUID, by the user deleting it
+func (n *NodeManager) ArchiveDeleteByUUID(uuid, user string) error {
+	if err := n.archiveDelete(uuid, "delete", user); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return fmt.Errorf("getNodeByUUID %v", err)
+		}
+		return err
+	}
+	return nil
+}
+
+// Helper to archive with a trigger and delete a node record by UUID, not found is returned as is
+func (n *NodeManager) archiveDelete(uuid, trigger, actor string) error {
 	node, err := n.GetByUUID(uuid)
 	if err != nil {
-		return fmt.Errorf("getNodeByUUID %v", err)
+		return err
 	}
-	archivedNode := nodeArchiveFromNode(node, "delete")
+	archivedNode := nodeArchiveFromNode(node, trigger)
 	if err := n.DB.Create(&archivedNode).Error; err != nil {
 		return fmt.Errorf("Create %v", err)
 	}
-	if err := n.DB.Unscoped().Delete(&node).Error; err != nil {
+	err = n.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Delete(&node).Error; err != nil {
+			return err
+		}
+		if err := RecordMembership(tx, envMembership(node, node.Environment, MembershipRemoved, actor)); err != nil {
+			return err
+		}
+		return updatePlatform(tx, node.EnvironmentID, node.Platform, -1)
+	})
+	if err != nil {
 		return fmt.Errorf("Delete %v", err)
 	}
+	n.invalidatePlatforms()
 	return nil
 }
 
@@ -487,6 +660,7 @@ func nodeArchiveFromNode(node OsqueryNode, trigger string) ArchiveOsqueryNode {
 		OsqueryVersion:  node.OsqueryVersion,
 		Hostname:        node.Hostname,
 		Localname:       node.Localname,
+		DisplayName:     node.DisplayName,
 		IPAddress:       node.IPAddress,
 		Username:        node.Username,
 		OsqueryUser:     node.OsqueryUser,
@@ -498,17 +672,56 @@ func nodeArchiveFromNode(node OsqueryNode, trigger string) ArchiveOsqueryNode {
 		ConfigHash:      node.ConfigHash,
 		BytesReceived:   node.BytesReceived,
 		RawEnrollment:   node.RawEnrollment,
+		EnrollMetadata:  node.EnrollMetadata,
 		LastStatus:      node.LastStatus,
 		LastResult:      node.LastResult,
 		LastConfig:      node.LastConfig,
 		LastQueryRead:   node.LastQueryRead,
 		LastQueryWrite:  node.LastQueryWrite,
+		AgentTime:       node.AgentTime,
+		ClockSkew:       node.ClockSkew,
 		UserID:          node.UserID,
 		EnvironmentID:   node.EnvironmentID,
 		ExtraData:       node.ExtraData,
 	}
 }
 
+// Helper to convert an archived osquery node back into an enrolled osquery node
+func nodeFromArchive(archived ArchiveOsqueryNode) OsqueryNode {
+	return OsqueryNode{
+		NodeKey:         archived.NodeKey,
+		UUID:            archived.UUID,
+		Platform:        archived.Platform,
+		PlatformVersion: archived.PlatformVersion,
+		OsqueryVersion:  archived.OsqueryVersion,
+		Hostname:        archived.Hostname,
+		Localname:       archived.Localname,
+		DisplayName:     archived.DisplayName,
+		IPAddress:       archived.IPAddress,
+		Username:        archived.Username,
+		OsqueryUser:     archived.OsqueryUser,
+		Environment:     archived.Environment,
+		CPU:             archived.CPU,
+		Memory:          archived.Memory,
+		HardwareSerial:  archived.HardwareSerial,
+		DaemonHash:      archived.DaemonHash,
+		ConfigHash:      archived.ConfigHash,
+		BytesReceived:   archived.BytesReceived,
+		RawEnrollment:   archived.RawEnrollment,
+		EnrollMetadata:  archived.EnrollMetadata,
+		LastStatus:      archived.LastStatus,
+		LastResult:      archived.LastResult,
+		LastConfig:      archived.LastConfig,
+		LastQueryRead:   archived.LastQueryRead,
+		LastQueryWrite:  archived.LastQueryWrite,
+		AgentTime:       archived.AgentTime,
+		ClockSkew:       archived.ClockSkew,
+		UserID:          archived.UserID,
+		EnvironmentID:   archived.EnvironmentID,
+		ExtraData:       archived.ExtraData,
+	}
+}
+
 // IncreaseBytesByUUID to update received bytes by UUID
 func (n *NodeManager) IncreaseBytesByUUID(uuid string, incBytes int) error {
 	node, err := n.GetByUUID(uuid)
@@ -544,8 +757,12 @@ func (n *NodeManager) ConfigRefresh(node OsqueryNode, lastIp string, incBytes in
 	if lastIp != "" {
 		updates["ip_address"] = lastIp
 	}
-	if err := n.DB.Model(&node).Updates(updates).Error; err != nil {
-		return fmt.Errorf("Updates %v", err)
+	// Values are absolute, so the update is safe to retry
+	err := backend.Retry(context.Background(), "nodes.ConfigRefresh", func() error {
+		return n.DB.Model(&node).Updates(updates).Error
+	})
+	if err != nil {
+		return fmt.Errorf("Updates %w", err)
 	}
 	return nil
 }
@@ -570,15 +787,24 @@ func (n *NodeManager) MetadataRefresh(node OsqueryNode, metadata NodeMetadata) e
 
 // QueryReadRefresh to perform all needed update operations per node in a query read request
 func (n *NodeManager) QueryReadRefresh(node OsqueryNode, lastIp string, incBytes int) error {
+	now := time.Now()
 	updates := map[string]interface{}{
-		"last_query_read": time.Now(),
+		"last_query_read": now,
 		"bytes_received":  node.BytesReceived + incBytes,
 	}
 	if lastIp != "" {
 		updates["ip_address"] = lastIp
 	}
-	if err := n.DB.Model(&node).Updates(updates).Error; err != nil {
-		return fmt.Errorf("Updates %v", err)
+	// Check-in history only changes once per hour
+	if history, changed := RecordCheckin(node, now); changed {
+		updates["checkin_history"] = history
+	}
+	// Values are absolute, so the update is safe to retry
+	err := backend.Retry(context.Background(), "nodes.QueryReadRefresh", func() error {
+		return n.DB.Model(&node).Updates(updates).Error
+	})
+	if err != nil {
+		return fmt.Errorf("Updates %w", err)
 	}
 	return nil
 }
@@ -592,8 +818,12 @@ func (n *NodeManager) QueryWriteRefresh(node OsqueryNode, lastIp string, incByte
 	if lastIp != "" {
 		updates["ip_address"] = lastIp
 	}
-	if err := n.DB.Model(&node).Updates(updates).Error; err != nil {
-		return fmt.Errorf("Updates %v", err)
+	// Values are absolute, so the update is safe to retry
+	err := backend.Retry(context.Background(), "nodes.QueryWriteRefresh", func() error {
+		return n.DB.Model(&node).Updates(updates).Error
+	})
+	if err != nil {
+		return fmt.Errorf("Updates %w", err)
 	}
 	return nil
 }
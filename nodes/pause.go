@@ -0,0 +1,232 @@
+package nodes
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jmpsec/osctrl/backend"
+	"gorm.io/gorm"
+)
+
+const (
+	// PauseExpiry is who unpauses nodes when their pause expires
+	PauseExpiry = "expiry"
+	// DefaultPauseInterval to check for expired pauses of nodes
+	DefaultPauseInterval = time.Minute
+)
+
+// NodePause to keep track of nodes that do not get queries nor carves, but keep sending logs
+type NodePause struct {
+	gorm.Model
+	UUID          string `gorm:"index"`
+	NodeID        uint
+	EnvironmentID uint `gorm:"index"`
+	Hostname      string
+	Reason        string
+	PausedBy      string
+	ExpiresAt     time.Time
+	Active        bool `gorm:"index"`
+	UnpausedBy    string
+	UnpausedAt    time.Time
+}
+
+// Expired to check if a pause is over at a given time, pauses without expiration never expire
+func (p NodePause) Expired(now time.Time) bool {
+	return !p.ExpiresAt.IsZero() && !now.Before(p.ExpiresAt)
+}
+
+// PauseSummary to describe a pause or unpause of a node for the activity of the environment
+func PauseSummary(p NodePause) string {
+	if !p.Active {
+		summary := "unpaused node " + p.Hostname
+		if p.UnpausedBy == PauseExpiry {
+			summary += " after the pause expired"
+		}
+		return summary
+	}
+	summary := "paused node " + p.Hostname
+	if !p.ExpiresAt.IsZero() {
+		summary += " until " + p.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+	if p.Reason != "" {
+		summary += ": " + p.Reason
+	}
+	return summary
+}
+
+// Pause to stop serving queries and carves to a node, the reason and expiration of an active pause are updated
+func (n *NodeManager) Pause(uuid, reason, user string, expires time.Time) (NodePause, error) {
+	node, err := n.GetByUUID(uuid)
+	if err != nil {
+		return NodePause{}, fmt.Errorf("getNodeByUUID %v", err)
+	}
+	if !expires.IsZero() && !expires.After(time.Now()) {
+		return NodePause{}, fmt.Errorf("expiration %s is in the past", expires.UTC().Format(time.RFC3339))
+	}
+	p, err := n.GetPause(node.UUID)
+	if err == nil && p.Active {
+		updates := map[string]interface{}{"reason": reason, "paused_by": user, "expires_at": expires}
+		if err := n.DB.Model(&p).Updates(updates).Error; err != nil {
+			return p, fmt.Errorf("Updates %v", err)
+		}
+		p.Reason, p.PausedBy, p.ExpiresAt = reason, user, expires
+		n.invalidatePauses()
+		return p, nil
+	}
+	p = NodePause{
+		UUID:          node.UUID,
+		NodeID:        node.ID,
+		EnvironmentID: node.EnvironmentID,
+		Hostname:      node.Hostname,
+		Reason:        reason,
+		PausedBy:      user,
+		ExpiresAt:     expires,
+		Active:        true,
+	}
+	if err := n.DB.Create(&p).Error; err != nil {
+		return p, fmt.Errorf("Create %v", err)
+	}
+	n.invalidatePauses()
+	return p, nil
+}
+
+// Unpause to serve again queries and carves to a paused node
+func (n *NodeManager) Unpause(uuid, user string) (NodePause, error) {
+	p, err := n.GetPause(uuid)
+	if err != nil || !p.Active {
+		return p, fmt.Errorf("node %s is not paused", uuid)
+	}
+	now := time.Now()
+	updates := map[string]interface{}{"active": false, "unpaused_by": user, "unpaused_at": now}
+	if err := n.DB.Model(&p).Updates(updates).Error; err != nil {
+		return p, fmt.Errorf("Updates %v", err)
+	}
+	p.Active, p.UnpausedBy, p.UnpausedAt = false, user, now
+	n.invalidatePauses()
+	return p, nil
+}
+
+// GetPause to get the latest pause of a node by UUID
+func (n *NodeManager) GetPause(uuid string) (NodePause, error) {
+	var p NodePause
+	if err := n.read().Where("uuid = ?", strings.ToUpper(uuid)).Order("created_at desc").First(&p).Error; err != nil {
+		return p, err
+	}
+	return p, nil
+}
+
+// GetPauses to get the active pauses, for one environment or all with envid 0
+func (n *NodeManager) GetPauses(envid uint) ([]NodePause, error) {
+	var ps []NodePause
+	query := n.read().Where("active = ?", true)
+	if envid != 0 {
+		query = query.Where("environment_id = ?", envid)
+	}
+	if err := query.Order("created_at desc").Find(&ps).Error; err != nil {
+		return ps, err
+	}
+	return ps, nil
+}
+
+// PausedUUIDs to get the UUIDs of paused nodes at a given time, for one environment or all with envid 0
+func (n *NodeManager) PausedUUIDs(envid uint, now time.Time) (map[string]bool, error) {
+	ps, err := n.GetPauses(envid)
+	if err != nil {
+		return nil, err
+	}
+	uuids := make(map[string]bool, len(ps))
+	for _, p := range ps {
+		if !p.Expired(now) {
+			uuids[p.UUID] = true
+		}
+	}
+	return uuids, nil
+}
+
+// Helper to force the next read of paused nodes from the DB
+func (n *NodeManager) invalidatePauses() {
+	n.pauses.mux.Lock()
+	n.pauses.expires = time.Time{}
+	n.pauses.mux.Unlock()
+}
+
+// IsPaused to check if a node by UUID is paused and must not get queries nor carves
+func (n *NodeManager) IsPaused(uuid string) (bool, error) {
+	n.pauses.mux.Lock()
+	defer n.pauses.mux.Unlock()
+	if time.Now().After(n.pauses.expires) {
+		uuids, err := n.PausedUUIDs(0, time.Now())
+		if err != nil {
+			return false, err
+		}
+		n.pauses.uuids = uuids
+		n.pauses.expires = time.Now().Add(decommissionCacheTTL)
+	}
+	return n.pauses.uuids[strings.ToUpper(uuid)], nil
+}
+
+// ExpirePauses to unpause the nodes with expired pauses, returning them to be recorded
+func (n *NodeManager) ExpirePauses(now time.Time) ([]NodePause, error) {
+	var ps []NodePause
+	if err := n.DB.Where("active = ? AND expires_at > ? AND expires_at <= ?", true, time.Time{}, now).Find(&ps).Error; err != nil {
+		return nil, fmt.Errorf("Find %v", err)
+	}
+	expired := make([]NodePause, 0, len(ps))
+	for _, p := range ps {
+		if !p.Expired(now) {
+			continue
+		}
+		updates := map[string]interface{}{"active": false, "unpaused_by": PauseExpiry, "unpaused_at": now}
+		if err := n.DB.Model(&p).Updates(updates).Error; err != nil {
+			return expired, fmt.Errorf("Updates %v", err)
+		}
+		p.Active, p.UnpausedBy, p.UnpausedAt = false, PauseExpiry, now
+		expired = append(expired, p)
+	}
+	if len(expired) > 0 {
+		n.invalidatePauses()
+	}
+	return expired, nil
+}
+
+// StartPauseExpiry to unpause nodes when their pause expires, only by the instance holding the leader lock
+func (n *NodeManager) StartPauseExpiry(ctx context.Context, leader *backend.LeaderLock, interval time.Duration, record func(NodePause)) {
+	if interval <= 0 {
+		interval = DefaultPauseInterval
+	}
+	run := func() {
+		if leader != nil {
+			isLeader, err := leader.Acquire()
+			if err != nil {
+				log.Printf("error acquiring leader lock %v", err)
+				return
+			}
+			if !isLeader {
+				return
+			}
+		}
+		expired, err := n.ExpirePauses(time.Now())
+		if err != nil {
+			log.Printf("error expiring paused nodes %v", err)
+		}
+		for _, p := range expired {
+			record(p)
+		}
+	}
+	go func() {
+		run()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				run()
+			}
+		}
+	}()
+}
@@ -0,0 +1,31 @@
+package nodes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPauseExpired(t *testing.T) {
+	now := time.Now()
+	p := NodePause{Active: true}
+	assert.False(t, p.Expired(now))
+	p.ExpiresAt = now.Add(time.Hour)
+	assert.False(t, p.Expired(now))
+	p.ExpiresAt = now
+	assert.True(t, p.Expired(now))
+}
+
+func TestPauseSummary(t *testing.T) {
+	p := NodePause{Hostname: "host1", Active: true}
+	assert.Equal(t, "paused node host1", PauseSummary(p))
+	p.Reason = "forensic imaging"
+	p.ExpiresAt = time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, "paused node host1 until 2024-05-10T12:00:00Z: forensic imaging", PauseSummary(p))
+	p.Active = false
+	p.UnpausedBy = "admin"
+	assert.Equal(t, "unpaused node host1", PauseSummary(p))
+	p.UnpausedBy = PauseExpiry
+	assert.Equal(t, "unpaused node host1 after the pause expired", PauseSummary(p))
+}
@@ -0,0 +1,178 @@
+package nodes
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// How long the platforms are cached before reading them again from the DB
+	platformsCacheTTL = 60 * time.Second
+	// Unique index of the platforms of an environment
+	platformsIndex = "idx_env_platform"
+)
+
+// EnvPlatform to keep the distinct platforms of nodes per environment
+type EnvPlatform struct {
+	gorm.Model
+	EnvironmentID uint   `gorm:"uniqueIndex:idx_env_platform"`
+	Platform      string `gorm:"uniqueIndex:idx_env_platform"`
+	Nodes         int
+}
+
+// platformsCache to keep platforms in memory between DB reads
+type platformsCache struct {
+	mux     sync.Mutex
+	all     []string
+	envs    map[uint][]string
+	expires time.Time
+}
+
+// Columns of the unique index of platforms, to insert or update them in one statement
+var platformColumns = []clause.Column{{Name: "environment_id"}, {Name: "platform"}}
+
+// Helper to add (or subtract) nodes to a platform of an environment, must be used within a transaction.
+// Each change is a single statement on the unique index, so concurrent enrolls do not duplicate platforms.
+func updatePlatform(tx *gorm.DB, envid uint, platform string, delta int) error {
+	if platform == "" || delta == 0 {
+		return nil
+	}
+	if delta > 0 {
+		p := EnvPlatform{EnvironmentID: envid, Platform: platform, Nodes: delta}
+		return tx.Clauses(clause.OnConflict{
+			Columns:   platformColumns,
+			DoUpdates: clause.Assignments(map[string]interface{}{"nodes": gorm.Expr("env_platforms.nodes + ?", delta), "updated_at": time.Now()}),
+		}).Create(&p).Error
+	}
+	err := tx.Model(&EnvPlatform{}).Where("environment_id = ? AND platform = ?", envid, platform).
+		Update("nodes", gorm.Expr("nodes + ?", delta)).Error
+	if err != nil {
+		return err
+	}
+	// Last node for this platform is gone
+	return tx.Unscoped().Where("environment_id = ? AND platform = ? AND nodes <= 0", envid, platform).Delete(&EnvPlatform{}).Error
+}
+
+// RefreshPlatforms to set the platforms of all environments to the counts of existing nodes. Platforms are
+// updated in place and only the ones without nodes are removed, so it can run while nodes enroll.
+func (n *NodeManager) RefreshPlatforms() error {
+	type platformCount struct {
+		EnvironmentID uint
+		Platform      string
+		Nodes         int
+	}
+	var counts []platformCount
+	if err := n.DB.Model(&OsqueryNode{}).Select("environment_id, platform, count(*) as nodes").Group("environment_id, platform").Scan(&counts).Error; err != nil {
+		return fmt.Errorf("Group %v", err)
+	}
+	err := n.DB.Transaction(func(tx *gorm.DB) error {
+		keep := make(map[string]bool)
+		for _, c := range counts {
+			if c.Platform == "" {
+				continue
+			}
+			keep[fmt.Sprintf("%d:%s", c.EnvironmentID, c.Platform)] = true
+			p := EnvPlatform{EnvironmentID: c.EnvironmentID, Platform: c.Platform, Nodes: c.Nodes}
+			err := tx.Clauses(clause.OnConflict{
+				Columns:   platformColumns,
+				DoUpdates: clause.AssignmentColumns([]string{"nodes", "updated_at"}),
+			}).Create(&p).Error
+			if err != nil {
+				return err
+			}
+		}
+		var existing []EnvPlatform
+		if err := tx.Find(&existing).Error; err != nil {
+			return err
+		}
+		var stale []uint
+		for _, e := range existing {
+			if !keep[fmt.Sprintf("%d:%s", e.EnvironmentID, e.Platform)] {
+				stale = append(stale, e.ID)
+			}
+		}
+		if len(stale) > 0 {
+			return tx.Unscoped().Delete(&EnvPlatform{}, stale).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Transaction %v", err)
+	}
+	n.invalidatePlatforms()
+	return nil
+}
+
+// Helper to clear platforms counted before the unique index, returns true if they must be rebuilt.
+// Platforms are derived from the nodes, so they are rebuilt instead of merging duplicates.
+func migratePlatforms(backend *gorm.DB) (bool, error) {
+	m := backend.Migrator()
+	if !m.HasTable(&EnvPlatform{}) || m.HasIndex(&EnvPlatform{}, platformsIndex) {
+		return false, nil
+	}
+	if err := backend.Session(&gorm.Session{AllowGlobalUpdate: true}).Unscoped().Delete(&EnvPlatform{}).Error; err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Helper to force the next read of platforms from the DB
+func (n *NodeManager) invalidatePlatforms() {
+	n.platforms.mux.Lock()
+	n.platforms.expires = time.Time{}
+	n.platforms.mux.Unlock()
+}
+
+// Helper to load platforms from the DB if the cache has expired
+func (n *NodeManager) loadPlatforms() (*platformsCache, error) {
+	n.platforms.mux.Lock()
+	defer n.platforms.mux.Unlock()
+	if time.Now().Before(n.platforms.expires) {
+		return &n.platforms, nil
+	}
+	var entries []EnvPlatform
+	if err := n.DB.Order("platform").Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	all := make(map[string]bool)
+	envs := make(map[uint][]string)
+	for _, e := range entries {
+		all[e.Platform] = true
+		envs[e.EnvironmentID] = append(envs[e.EnvironmentID], e.Platform)
+	}
+	n.platforms.all = make([]string, 0, len(all))
+	for p := range all {
+		n.platforms.all = append(n.platforms.all, p)
+	}
+	sort.Strings(n.platforms.all)
+	n.platforms.envs = envs
+	n.platforms.expires = time.Now().Add(platformsCacheTTL)
+	return &n.platforms, nil
+}
+
+// GetAllPlatforms to get all the platforms with nodes, across environments
+func (n *NodeManager) GetAllPlatforms() ([]string, error) {
+	cache, err := n.loadPlatforms()
+	if err != nil {
+		return []string{}, fmt.Errorf("loadPlatforms %v", err)
+	}
+	n.platforms.mux.Lock()
+	defer n.platforms.mux.Unlock()
+	return append([]string{}, cache.all...), nil
+}
+
+// GetEnvPlatforms to get the platforms with nodes in one environment
+func (n *NodeManager) GetEnvPlatforms(envid uint) ([]string, error) {
+	cache, err := n.loadPlatforms()
+	if err != nil {
+		return []string{}, fmt.Errorf("loadPlatforms %v", err)
+	}
+	n.platforms.mux.Lock()
+	defer n.platforms.mux.Unlock()
+	return append([]string{}, cache.envs[envid]...), nil
+}
@@ -0,0 +1,76 @@
+package nodes
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// Helper to get a node manager with a mocked postgres DB
+func newNodesMock(t *testing.T) (*NodeManager, sqlmock.Sqlmock) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db, got error: %v", err)
+	}
+	t.Cleanup(func() { mockDB.Close() })
+	_postgres, err := gorm.Open(postgres.New(postgres.Config{Conn: mockDB}), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("unable to create new postgres database: %v", err)
+	}
+	return &NodeManager{DB: _postgres}, mock
+}
+
+func TestUpdatePlatformAdd(t *testing.T) {
+	n, mock := newNodesMock(t)
+	// Concurrent enrolls of a new platform add to the same row
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "env_platforms" .* ON CONFLICT \("environment_id","platform"\) DO UPDATE SET "nodes"=env_platforms.nodes \+ \$\d+,"updated_at"=\$\d+`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+	err := n.DB.Transaction(func(tx *gorm.DB) error {
+		return updatePlatform(tx, 1, "darwin", 1)
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdatePlatformRemove(t *testing.T) {
+	n, mock := newNodesMock(t)
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "env_platforms" SET "nodes"=nodes \+ \$1,"updated_at"=\$2 WHERE \(environment_id = \$3 AND platform = \$4\)`).
+		WithArgs(-1, sqlmock.AnyArg(), 1, "darwin").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM "env_platforms" WHERE environment_id = \$1 AND platform = \$2 AND nodes <= 0`).
+		WithArgs(1, "darwin").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	err := n.DB.Transaction(func(tx *gorm.DB) error {
+		if err := updatePlatform(tx, 1, "", -1); err != nil {
+			return err
+		}
+		return updatePlatform(tx, 1, "darwin", -1)
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRefreshPlatformsInPlace(t *testing.T) {
+	n, mock := newNodesMock(t)
+	mock.ExpectQuery(`SELECT environment_id, platform, count\(\*\) as nodes FROM "osquery_nodes"`).
+		WillReturnRows(sqlmock.NewRows([]string{"environment_id", "platform", "nodes"}).AddRow(1, "darwin", 3).AddRow(1, "", 1))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "env_platforms" .* ON CONFLICT \("environment_id","platform"\) DO UPDATE SET "nodes"="excluded"."nodes","updated_at"="excluded"."updated_at"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectQuery(`SELECT \* FROM "env_platforms"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "environment_id", "platform", "nodes"}).AddRow(1, 1, "darwin", 3).AddRow(2, 1, "ubuntu", 1))
+	// Only the platform without nodes is removed, the table is not truncated
+	mock.ExpectExec(`DELETE FROM "env_platforms" WHERE "env_platforms"."id" = \$1`).
+		WithArgs(2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	assert.NoError(t, n.RefreshPlatforms())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,141 @@
+package nodes
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// RejectionRejected for nodes that were not enrolled because the platform is not allowed
+	RejectionRejected string = "rejected"
+	// RejectionPending for nodes enrolled in quarantine, without schedule or queries until approved
+	RejectionPending string = "pending"
+	// RejectionFlagged for enrolled nodes out of policy after the allowed platforms changed
+	RejectionFlagged string = "flagged"
+	// RejectionApproved for quarantined or flagged nodes approved by a user
+	RejectionApproved string = "approved"
+)
+
+// EnrollRejection to keep track of nodes with platforms not allowed in their environment
+type EnrollRejection struct {
+	gorm.Model
+	UUID            string `gorm:"index"`
+	EnvironmentID   uint   `gorm:"index"`
+	Hostname        string
+	Platform        string
+	PlatformVersion string
+	IPAddress       string
+	Allowed         string
+	State           string
+	Reviewer        string
+}
+
+// RecordRejection to record an enroll with a platform not allowed
+func (n *NodeManager) RecordRejection(r EnrollRejection) error {
+	r.UUID = strings.ToUpper(r.UUID)
+	if err := n.DB.Create(&r).Error; err != nil {
+		return fmt.Errorf("Create %v", err)
+	}
+	if r.State == RejectionPending {
+		n.invalidateQuarantines()
+	}
+	return nil
+}
+
+// GetRejections to get enroll rejections by state, all states if empty, for one environment or all with envid 0
+func (n *NodeManager) GetRejections(envid uint, state string) ([]EnrollRejection, error) {
+	var rs []EnrollRejection
+	query := n.read()
+	if envid != 0 {
+		query = query.Where("environment_id = ?", envid)
+	}
+	if state != "" {
+		query = query.Where("state = ?", state)
+	}
+	if err := query.Order("created_at desc").Find(&rs).Error; err != nil {
+		return rs, err
+	}
+	return rs, nil
+}
+
+// ApproveRejection to approve a quarantined or flagged node of an environment, rejected enrolls can not be approved
+func (n *NodeManager) ApproveRejection(envid, id uint, reviewer string) (EnrollRejection, error) {
+	var r EnrollRejection
+	if err := n.DB.Where("id = ? AND environment_id = ?", id, envid).First(&r).Error; err != nil {
+		return r, err
+	}
+	if r.State != RejectionPending && r.State != RejectionFlagged {
+		return r, fmt.Errorf("enroll %d is %s and can not be approved", id, r.State)
+	}
+	if err := n.DB.Model(&r).Updates(map[string]interface{}{"state": RejectionApproved, "reviewer": reviewer}).Error; err != nil {
+		return r, fmt.Errorf("Updates %v", err)
+	}
+	n.invalidateQuarantines()
+	return r, nil
+}
+
+// FlagPlatforms to flag the enrolled nodes of an environment with platforms no longer allowed.
+// Nodes keep working, already flagged or quarantined nodes are not flagged again.
+func (n *NodeManager) FlagPlatforms(envid uint, allowed string, isAllowed func(platform string) bool) (int, error) {
+	var nodes []OsqueryNode
+	if err := n.DB.Where("environment_id = ?", envid).Find(&nodes).Error; err != nil {
+		return 0, fmt.Errorf("Find %v", err)
+	}
+	var flagged []string
+	if err := n.DB.Model(&EnrollRejection{}).Where("environment_id = ? AND state IN ?", envid, []string{RejectionPending, RejectionFlagged}).Pluck("uuid", &flagged).Error; err != nil {
+		return 0, fmt.Errorf("Pluck %v", err)
+	}
+	skip := make(map[string]bool, len(flagged))
+	for _, u := range flagged {
+		skip[u] = true
+	}
+	count := 0
+	for _, node := range nodes {
+		if isAllowed(node.Platform) || skip[node.UUID] {
+			continue
+		}
+		r := EnrollRejection{
+			UUID:            node.UUID,
+			EnvironmentID:   envid,
+			Hostname:        node.Hostname,
+			Platform:        node.Platform,
+			PlatformVersion: node.PlatformVersion,
+			IPAddress:       node.IPAddress,
+			Allowed:         allowed,
+			State:           RejectionFlagged,
+		}
+		if err := n.DB.Create(&r).Error; err != nil {
+			return count, fmt.Errorf("Create %v", err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+// Helper to force the next read of quarantined nodes from the DB
+func (n *NodeManager) invalidateQuarantines() {
+	n.quarantines.mux.Lock()
+	n.quarantines.expires = time.Time{}
+	n.quarantines.mux.Unlock()
+}
+
+// IsQuarantined to check if a node by UUID was enrolled in quarantine and is not approved yet
+func (n *NodeManager) IsQuarantined(uuid string) (bool, error) {
+	n.quarantines.mux.Lock()
+	defer n.quarantines.mux.Unlock()
+	if time.Now().After(n.quarantines.expires) {
+		var uuids []string
+		if err := n.read().Model(&EnrollRejection{}).Where("state = ?", RejectionPending).Pluck("uuid", &uuids).Error; err != nil {
+			return false, err
+		}
+		n.quarantines.uuids = make(map[string]bool, len(uuids))
+		for _, u := range uuids {
+			n.quarantines.uuids[u] = true
+		}
+		n.quarantines.expires = time.Now().Add(decommissionCacheTTL)
+	}
+	return n.quarantines.uuids[strings.ToUpper(uuid)], nil
+}
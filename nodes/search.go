@@ -0,0 +1,206 @@
+package nodes
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// SearchUUID for nodes found by UUID
+	SearchUUID string = "uuid"
+	// SearchDisplayName for nodes found by their normalized name
+	SearchDisplayName string = "display_name"
+	// SearchHostname for nodes found by hostname
+	SearchHostname string = "hostname"
+	// SearchLocalname for nodes found by localname
+	SearchLocalname string = "localname"
+	// SearchIPAddress for nodes found by their current IP address
+	SearchIPAddress string = "ip_address"
+	// SearchSerial for nodes found by hardware serial
+	SearchSerial string = "hardware_serial"
+	// SearchIPHistory for nodes found by an IP address they had before
+	SearchIPHistory string = "ip_history"
+	// DefaultSearchLimit is the default number of nodes returned by a search
+	DefaultSearchLimit int = 50
+	// MinSearchLength is the minimum length of a search term, so searches use the indexes
+	MinSearchLength int = 3
+	// NodePath is the path of the page of a node in osctrl-admin
+	NodePath string = "/node/"
+)
+
+// Columns of nodes searched by prefix, in the order used to report the match
+var searchColumns = []string{SearchUUID, SearchDisplayName, SearchHostname, SearchLocalname, SearchIPAddress, SearchSerial}
+
+// NodeMatch to return a node found by a search, with the field and value that matched
+type NodeMatch struct {
+	UUID          string    `json:"uuid"`
+	DisplayName   string    `json:"display_name"`
+	Hostname      string    `json:"hostname"`
+	Localname     string    `json:"localname"`
+	IPAddress     string    `json:"ip_address"`
+	Platform      string    `json:"platform"`
+	Serial        string    `json:"hardware_serial"`
+	EnvironmentID uint      `json:"environment_id"`
+	Environment   string    `json:"environment"`
+	Field         string    `json:"field"`
+	Value         string    `json:"value"`
+	LastSeen      time.Time `json:"last_seen"`
+	Path          string    `json:"path"`
+}
+
+// Helper to create the prefix indexes used by searches, so they stay fast with many nodes.
+// Expressions with text_pattern_ops are used by LIKE 'prefix%' in any collation.
+func createSearchIndexes(backend *gorm.DB) {
+	indexes := map[string]string{
+		"idx_search_nodes_uuid":        "osquery_nodes (lower(uuid) text_pattern_ops)",
+		"idx_search_nodes_displayname": "osquery_nodes (lower(display_name) text_pattern_ops)",
+		"idx_search_nodes_hostname":    "osquery_nodes (lower(hostname) text_pattern_ops)",
+		"idx_search_nodes_localname":   "osquery_nodes (lower(localname) text_pattern_ops)",
+		"idx_search_nodes_ipaddress":   "osquery_nodes (lower(ip_address) text_pattern_ops)",
+		"idx_search_nodes_serial":      "osquery_nodes (lower(hardware_serial) text_pattern_ops)",
+		"idx_search_history_ipaddress": "node_history_ip_addresses (lower(ip_address) text_pattern_ops)",
+	}
+	for name, def := range indexes {
+		if err := backend.Exec("CREATE INDEX IF NOT EXISTS " + name + " ON " + def).Error; err != nil {
+			log.Printf("Failed to create index (%s): %v", name, err)
+		}
+	}
+}
+
+// SearchPattern to get the LIKE pattern for values starting with a term, case insensitive
+func SearchPattern(term string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(strings.ToLower(strings.TrimSpace(term))) + "%"
+}
+
+// Helper to get the value of a searched column of a node
+func searchValue(node OsqueryNode, column string) string {
+	switch column {
+	case SearchUUID:
+		return node.UUID
+	case SearchDisplayName:
+		return node.DisplayName
+	case SearchHostname:
+		return node.Hostname
+	case SearchLocalname:
+		return node.Localname
+	case SearchIPAddress:
+		return node.IPAddress
+	case SearchSerial:
+		return node.HardwareSerial
+	}
+	return ""
+}
+
+// MatchNode to get the first field of a node starting with a term, case insensitive
+func MatchNode(node OsqueryNode, term string) (string, string, bool) {
+	term = strings.ToLower(strings.TrimSpace(term))
+	for _, c := range searchColumns {
+		v := searchValue(node, c)
+		if v != "" && strings.HasPrefix(strings.ToLower(v), term) {
+			return c, v, true
+		}
+	}
+	return "", "", false
+}
+
+// Helper to prepare a match of a node
+func nodeMatch(node OsqueryNode, field, value string) NodeMatch {
+	return NodeMatch{
+		UUID:          node.UUID,
+		DisplayName:   node.DisplayName,
+		Hostname:      node.Hostname,
+		Localname:     node.Localname,
+		IPAddress:     node.IPAddress,
+		Platform:      node.Platform,
+		Serial:        node.HardwareSerial,
+		EnvironmentID: node.EnvironmentID,
+		Environment:   node.Environment,
+		Field:         field,
+		Value:         value,
+		LastSeen:      node.UpdatedAt,
+		Path:          NodePath + node.UUID,
+	}
+}
+
+// Search to find nodes in the given environments by the start of their UUID, hostname, localname,
+// serial or current or previous IP address, or by attributes with attr:filter. Only nodes of the given
+// environments are returned.
+func (n *NodeManager) Search(ctx context.Context, term string, envIDs []uint, limit int) ([]NodeMatch, error) {
+	matches := []NodeMatch{}
+	if strings.HasPrefix(strings.TrimSpace(term), AttributeFilterPrefix) {
+		filter, err := ParseAttributeFilter(term)
+		if err != nil {
+			return matches, err
+		}
+		return n.SearchAttributes(ctx, filter, envIDs, limit)
+	}
+	if len(strings.TrimSpace(term)) < MinSearchLength {
+		return matches, fmt.Errorf("search term must be at least %d characters", MinSearchLength)
+	}
+	if len(envIDs) == 0 {
+		return matches, nil
+	}
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+	pattern := SearchPattern(term)
+	var conditions []string
+	var args []interface{}
+	for _, c := range searchColumns {
+		conditions = append(conditions, "lower("+c+") LIKE ?")
+		args = append(args, pattern)
+	}
+	var found []OsqueryNode
+	err := n.read().WithContext(ctx).Where("environment_id IN ?", envIDs).Where(strings.Join(conditions, " OR "), args...).
+		Order("updated_at DESC").Limit(limit).Find(&found).Error
+	if err != nil {
+		return matches, fmt.Errorf("Find %v", err)
+	}
+	seen := make(map[string]bool)
+	for _, node := range found {
+		field, value, _ := MatchNode(node, term)
+		matches = append(matches, nodeMatch(node, field, value))
+		seen[node.UUID] = true
+	}
+	if len(matches) >= limit {
+		return matches, nil
+	}
+	// Nodes of the same environments that had the IP address before
+	var history []NodeHistoryIPAddress
+	err = n.read().WithContext(ctx).Model(&NodeHistoryIPAddress{}).
+		Select("node_history_ip_addresses.uuid, node_history_ip_addresses.ip_address").
+		Joins("JOIN osquery_nodes ON osquery_nodes.uuid = node_history_ip_addresses.uuid AND osquery_nodes.deleted_at IS NULL").
+		Where("osquery_nodes.environment_id IN ? AND lower(node_history_ip_addresses.ip_address) LIKE ?", envIDs, pattern).
+		Order("node_history_ip_addresses.last_seen DESC").Limit(limit).Find(&history).Error
+	if err != nil {
+		return matches, fmt.Errorf("Find %v", err)
+	}
+	previous := make(map[string]string)
+	var uuids []string
+	for _, h := range history {
+		if seen[h.UUID] || previous[h.UUID] != "" {
+			continue
+		}
+		previous[h.UUID] = h.IPAddress
+		uuids = append(uuids, h.UUID)
+	}
+	if len(uuids) == 0 {
+		return matches, nil
+	}
+	found = nil
+	err = n.read().WithContext(ctx).Where("uuid IN ? AND environment_id IN ?", uuids, envIDs).
+		Order("updated_at DESC").Limit(limit - len(matches)).Find(&found).Error
+	if err != nil {
+		return matches, fmt.Errorf("Find %v", err)
+	}
+	for _, node := range found {
+		matches = append(matches, nodeMatch(node, SearchIPHistory, previous[node.UUID]))
+	}
+	return matches, nil
+}
@@ -0,0 +1,39 @@
+package nodes
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchPattern(t *testing.T) {
+	assert.Equal(t, "web-01%", SearchPattern(" WEB-01 "))
+	assert.Equal(t, `10\_0\%\\%`, SearchPattern(`10_0%\`))
+}
+
+func TestMatchNode(t *testing.T) {
+	node := OsqueryNode{
+		UUID:           "4C4C4544-0042-3010-8052-B4C04F4A4E32",
+		Hostname:       "web-01.example.com",
+		Localname:      "web-01",
+		IPAddress:      "10.0.4.12",
+		HardwareSerial: "C02XK1Y9JGH5",
+	}
+	field, value, ok := MatchNode(node, "4c4c")
+	assert.True(t, ok)
+	assert.Equal(t, SearchUUID, field)
+	assert.Equal(t, node.UUID, value)
+	field, value, ok = MatchNode(node, "WEB-01")
+	assert.True(t, ok)
+	assert.Equal(t, SearchHostname, field)
+	assert.Equal(t, node.Hostname, value)
+	field, _, ok = MatchNode(node, "10.0.4")
+	assert.True(t, ok)
+	assert.Equal(t, SearchIPAddress, field)
+	field, _, ok = MatchNode(node, "c02xk")
+	assert.True(t, ok)
+	assert.Equal(t, SearchSerial, field)
+	// Only the start of values matches
+	_, _, ok = MatchNode(node, "example")
+	assert.False(t, ok)
+}
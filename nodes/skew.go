@@ -0,0 +1,159 @@
+package nodes
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// DefaultClockSkew is the default threshold in seconds to flag nodes with a skewed clock
+	DefaultClockSkew int64 = 300
+)
+
+// SkewBucket to count nodes by how far their clock is from the server, up to Max seconds
+type SkewBucket struct {
+	Label string `json:"label"`
+	Max   int64  `json:"max"`
+	Count int    `json:"count"`
+}
+
+// SkewDistribution to expose how the clocks of the nodes of an environment drift from the server
+type SkewDistribution struct {
+	Environment string       `json:"environment"`
+	Threshold   int64        `json:"threshold"`
+	Nodes       int          `json:"nodes"`
+	Skewed      int          `json:"skewed"`
+	Ahead       int          `json:"ahead"`
+	Behind      int          `json:"behind"`
+	MaxAhead    int64        `json:"max_ahead"`
+	MaxBehind   int64        `json:"max_behind"`
+	Median      int64        `json:"median"`
+	Buckets     []SkewBucket `json:"buckets"`
+}
+
+// Upper bounds in seconds of the absolute skew of each bucket, the last one has no bound
+var skewBounds = []struct {
+	label string
+	max   int64
+}{
+	{"<1s", 1},
+	{"<10s", 10},
+	{"<1m", 60},
+	{"<5m", 300},
+	{"<1h", 3600},
+	{"<1d", 86400},
+	{">=1d", 0},
+}
+
+// ClockSkew to get the skew in seconds of the clock of a node, positive when the node is ahead of the server
+func ClockSkew(agent, server time.Time) int64 {
+	return int64(agent.Sub(server) / time.Second)
+}
+
+// Helper to get the absolute value of a skew
+func absSkew(skew int64) int64 {
+	if skew < 0 {
+		return -skew
+	}
+	return skew
+}
+
+// ClockSkewed to check if the clock of a node is further from the server than the threshold in seconds.
+// Nodes that never reported a time are never skewed.
+func ClockSkewed(node OsqueryNode, threshold int64) bool {
+	if node.AgentTime.IsZero() {
+		return false
+	}
+	if threshold <= 0 {
+		threshold = DefaultClockSkew
+	}
+	return absSkew(node.ClockSkew) > threshold
+}
+
+// FormatSkew to display a skew in seconds as ahead or behind the server
+func FormatSkew(skew int64) string {
+	if skew == 0 {
+		return "in sync"
+	}
+	d := (time.Duration(absSkew(skew)) * time.Second).String()
+	if skew > 0 {
+		return d + " ahead"
+	}
+	return d + " behind"
+}
+
+// RecordAgentTime to keep the newest time reported by a node and its skew against the time the server received it
+func (n *NodeManager) RecordAgentTime(node OsqueryNode, agent, received time.Time) error {
+	if received.IsZero() {
+		received = time.Now()
+	}
+	updates := map[string]interface{}{
+		"agent_time": agent,
+		"clock_skew": ClockSkew(agent, received),
+	}
+	if err := n.DB.Model(&node).Updates(updates).Error; err != nil {
+		return fmt.Errorf("Updates %v", err)
+	}
+	return nil
+}
+
+// GetSkewDistribution to get the distribution of clock skew of the nodes of an environment that reported a time
+func (n *NodeManager) GetSkewDistribution(environment string, threshold int64) (SkewDistribution, error) {
+	var skews []int64
+	err := n.read().Model(&OsqueryNode{}).
+		Where("environment = ? AND agent_time > ?", environment, time.Time{}).
+		Pluck("clock_skew", &skews).Error
+	if err != nil {
+		return SkewDistribution{Environment: environment}, err
+	}
+	distribution := SkewDistributionOf(skews, threshold)
+	distribution.Environment = environment
+	return distribution, nil
+}
+
+// SkewDistributionOf to get the distribution of a list of skews in seconds
+func SkewDistributionOf(skews []int64, threshold int64) SkewDistribution {
+	if threshold <= 0 {
+		threshold = DefaultClockSkew
+	}
+	d := SkewDistribution{
+		Threshold: threshold,
+		Nodes:     len(skews),
+		Buckets:   make([]SkewBucket, len(skewBounds)),
+	}
+	for i, b := range skewBounds {
+		d.Buckets[i] = SkewBucket{Label: b.label, Max: b.max}
+	}
+	if len(skews) == 0 {
+		return d
+	}
+	sorted := append([]int64{}, skews...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	d.Median = sorted[len(sorted)/2]
+	for _, s := range sorted {
+		abs := absSkew(s)
+		if abs > threshold {
+			d.Skewed++
+		}
+		if s > 0 {
+			d.Ahead++
+			if s > d.MaxAhead {
+				d.MaxAhead = s
+			}
+		}
+		if s < 0 {
+			d.Behind++
+			if abs > d.MaxBehind {
+				d.MaxBehind = abs
+			}
+		}
+		for i := range d.Buckets {
+			if d.Buckets[i].Max == 0 || abs < d.Buckets[i].Max {
+				d.Buckets[i].Count++
+				break
+			}
+		}
+	}
+	return d
+}
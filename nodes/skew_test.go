@@ -0,0 +1,42 @@
+package nodes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClockSkew(t *testing.T) {
+	server := time.Date(2022, 3, 8, 14, 25, 0, 0, time.UTC)
+	assert.Equal(t, int64(90), ClockSkew(server.Add(90*time.Second), server))
+	assert.Equal(t, int64(-3600), ClockSkew(server.Add(-time.Hour), server))
+	node := OsqueryNode{AgentTime: server.Add(-time.Hour), ClockSkew: -3600}
+	assert.True(t, ClockSkewed(node, 300))
+	assert.False(t, ClockSkewed(node, 7200))
+	// Nodes that never reported a time are not flagged
+	assert.False(t, ClockSkewed(OsqueryNode{ClockSkew: -3600}, 300))
+	assert.Equal(t, "in sync", FormatSkew(0))
+	assert.Equal(t, "1h0m0s behind", FormatSkew(-3600))
+	assert.Equal(t, "1m30s ahead", FormatSkew(90))
+}
+
+func TestSkewDistributionOf(t *testing.T) {
+	d := SkewDistributionOf([]int64{0, 5, -30, 400, -90000, 1}, 0)
+	assert.Equal(t, DefaultClockSkew, d.Threshold)
+	assert.Equal(t, 6, d.Nodes)
+	assert.Equal(t, 2, d.Skewed)
+	assert.Equal(t, 3, d.Ahead)
+	assert.Equal(t, 2, d.Behind)
+	assert.Equal(t, int64(400), d.MaxAhead)
+	assert.Equal(t, int64(90000), d.MaxBehind)
+	assert.Equal(t, int64(1), d.Median)
+	counts := map[string]int{}
+	for _, b := range d.Buckets {
+		counts[b.Label] = b.Count
+	}
+	assert.Equal(t, map[string]int{"<1s": 1, "<10s": 2, "<1m": 1, "<5m": 0, "<1h": 1, "<1d": 0, ">=1d": 1}, counts)
+	empty := SkewDistributionOf(nil, 60)
+	assert.Equal(t, 0, empty.Nodes)
+	assert.Equal(t, len(skewBounds), len(empty.Buckets))
+}
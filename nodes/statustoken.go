@@ -0,0 +1,181 @@
+package nodes
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultStatusTokenHours is the default maximum lifetime of node status tokens
+	DefaultStatusTokenHours = 24
+	// StatusTokenPath is the path in osctrl-admin to show the status of nodes with status tokens
+	StatusTokenPath = "/status/"
+	// Version of the status tokens, to be able to change the format
+	statusTokenVersion = "s1"
+	// Context to derive the status tokens key from the shared secret
+	statusTokenContext = "osctrl-node-status"
+	// Enrollment states shown in the node status page
+	StatusEnrolled       = "enrolled"
+	StatusDecommissioned = "decommissioned"
+	// Config sync states shown in the node status page
+	ConfigSynced  = "in sync"
+	ConfigPending = "pending"
+	ConfigNever   = "never"
+)
+
+// NodeStatus to show the state of one node to help desk, without query results
+type NodeStatus struct {
+	Hostname       string    `json:"hostname"`
+	UUID           string    `json:"uuid"`
+	Environment    string    `json:"environment"`
+	Platform       string    `json:"platform"`
+	OsqueryVersion string    `json:"osquery_version"`
+	Enrollment     string    `json:"enrollment"`
+	Active         bool      `json:"active"`
+	ConfigSync     string    `json:"config_sync"`
+	EnrolledAt     time.Time `json:"enrolled_at"`
+	LastStatus     time.Time `json:"last_status"`
+	LastResult     time.Time `json:"last_result"`
+	LastConfig     time.Time `json:"last_config"`
+	LastQueryRead  time.Time `json:"last_query_read"`
+	LastQueryWrite time.Time `json:"last_query_write"`
+	ExpiresAt      time.Time `json:"expires_at"`
+}
+
+// StatusTokenKey to derive the key to sign node status tokens from the secret shared by osctrl-admin and osctrl-api,
+// there is no key without secret so status tokens can not be generated or verified
+func StatusTokenKey(secret string) []byte {
+	if secret == "" {
+		return nil
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(statusTokenContext))
+	return mac.Sum(nil)
+}
+
+// Helper to sign the payload of a status token
+func statusTokenMAC(key []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// NewStatusToken to generate a signed token for the status of one node, valid until expires
+func NewStatusToken(key []byte, envUUID, nodeUUID string, issued, expires time.Time) (string, error) {
+	if len(key) == 0 {
+		return "", fmt.Errorf("empty key")
+	}
+	if envUUID == "" || nodeUUID == "" {
+		return "", fmt.Errorf("environment and node are required")
+	}
+	if strings.Contains(envUUID, "|") || strings.Contains(nodeUUID, "|") {
+		return "", fmt.Errorf("invalid environment or node")
+	}
+	if !expires.After(issued) {
+		return "", fmt.Errorf("expiration must be after issue time")
+	}
+	payload := strings.Join([]string{
+		statusTokenVersion,
+		envUUID,
+		nodeUUID,
+		strconv.FormatInt(issued.Unix(), 10),
+		strconv.FormatInt(expires.Unix(), 10),
+	}, "|")
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString([]byte(payload)) + "." + enc.EncodeToString(statusTokenMAC(key, payload)), nil
+}
+
+// CheckStatusToken to verify a node status token at a given time, returning the environment and node it is bound to.
+// Tokens living longer than maxLifetime are rejected, so lowering the setting also revokes existing longer tokens.
+func CheckStatusToken(key []byte, token string, now time.Time, maxLifetime time.Duration) (string, string, bool) {
+	if len(key) == 0 {
+		return "", "", false
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	enc := base64.RawURLEncoding
+	rawPayload, err := enc.DecodeString(parts[0])
+	if err != nil {
+		return "", "", false
+	}
+	signature, err := enc.DecodeString(parts[1])
+	if err != nil {
+		return "", "", false
+	}
+	payload := string(rawPayload)
+	if !hmac.Equal(signature, statusTokenMAC(key, payload)) {
+		return "", "", false
+	}
+	fields := strings.Split(payload, "|")
+	if len(fields) != 5 || fields[0] != statusTokenVersion {
+		return "", "", false
+	}
+	issued, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil {
+		return "", "", false
+	}
+	expires, err := strconv.ParseInt(fields[4], 10, 64)
+	if err != nil {
+		return "", "", false
+	}
+	if expires <= issued || time.Duration(expires-issued)*time.Second > maxLifetime {
+		return "", "", false
+	}
+	if now.Unix() >= expires {
+		return "", "", false
+	}
+	return fields[1], fields[2], true
+}
+
+// StatusTokenExpiration to get when a status token expires, from its payload and without verifying it
+func StatusTokenExpiration(token string) time.Time {
+	parts := strings.Split(token, ".")
+	rawPayload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return time.Time{}
+	}
+	fields := strings.Split(string(rawPayload), "|")
+	if len(fields) != 5 {
+		return time.Time{}
+	}
+	expires, err := strconv.ParseInt(fields[4], 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(expires, 0)
+}
+
+// StatusOfNode to get the status of one node to be shown with a status token.
+// The configuration is in sync when the node retrieved it after the last change of the environment.
+func StatusOfNode(n OsqueryNode, envName string, envUpdated time.Time, inactive int64) NodeStatus {
+	status := NodeStatus{
+		Hostname:       n.Hostname,
+		UUID:           n.UUID,
+		Environment:    envName,
+		Platform:       n.Platform,
+		OsqueryVersion: n.OsqueryVersion,
+		Enrollment:     StatusEnrolled,
+		Active:         IsActive(n, inactive),
+		ConfigSync:     ConfigNever,
+		EnrolledAt:     n.CreatedAt,
+		LastStatus:     n.LastStatus,
+		LastResult:     n.LastResult,
+		LastConfig:     n.LastConfig,
+		LastQueryRead:  n.LastQueryRead,
+		LastQueryWrite: n.LastQueryWrite,
+	}
+	if !n.LastConfig.IsZero() {
+		status.ConfigSync = ConfigPending
+		if !n.LastConfig.Before(envUpdated) {
+			status.ConfigSync = ConfigSynced
+		}
+	}
+	return status
+}
@@ -0,0 +1,95 @@
+package nodes
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestStatusToken(t *testing.T) {
+	key := StatusTokenKey("secret")
+	now := time.Now()
+	token, err := NewStatusToken(key, "env-uuid", "node-uuid", now, now.Add(time.Hour))
+	assert.NoError(t, err)
+	env, node, ok := CheckStatusToken(key, token, now, 24*time.Hour)
+	assert.True(t, ok)
+	assert.Equal(t, "env-uuid", env)
+	assert.Equal(t, "node-uuid", node)
+	assert.Equal(t, now.Add(time.Hour).Unix(), StatusTokenExpiration(token).Unix())
+	// Expired
+	_, _, ok = CheckStatusToken(key, token, now.Add(time.Hour), 24*time.Hour)
+	assert.False(t, ok)
+	// Lifetime longer than the maximum allowed
+	_, _, ok = CheckStatusToken(key, token, now, 30*time.Minute)
+	assert.False(t, ok)
+	// Signed with another key
+	_, _, ok = CheckStatusToken(StatusTokenKey("other"), token, now, 24*time.Hour)
+	assert.False(t, ok)
+	// Empty key
+	_, _, ok = CheckStatusToken(nil, token, now, 24*time.Hour)
+	assert.False(t, ok)
+	// No key without secret
+	assert.Nil(t, StatusTokenKey(""))
+	_, err = NewStatusToken(StatusTokenKey(""), "env-uuid", "node-uuid", now, now.Add(time.Hour))
+	assert.Error(t, err)
+}
+
+func TestStatusTokenForged(t *testing.T) {
+	key := StatusTokenKey("secret")
+	now := time.Now()
+	token, err := NewStatusToken(key, "env-uuid", "node-uuid", now, now.Add(time.Hour))
+	assert.NoError(t, err)
+	other, err := NewStatusToken(key, "env-uuid", "other-uuid", now, now.Add(time.Hour))
+	assert.NoError(t, err)
+	parts := strings.Split(token, ".")
+	otherParts := strings.Split(other, ".")
+	for _, forged := range []string{
+		"",
+		".",
+		parts[0],
+		parts[0] + ".",
+		parts[0] + "." + otherParts[1],
+		otherParts[0] + "." + parts[1],
+		parts[0] + "." + parts[1] + ".",
+		parts[0] + "." + parts[1][1:],
+		"!!!." + parts[1],
+	} {
+		_, _, ok := CheckStatusToken(key, forged, now, 24*time.Hour)
+		assert.False(t, ok, forged)
+	}
+}
+
+func TestNewStatusTokenErrors(t *testing.T) {
+	key := StatusTokenKey("secret")
+	now := time.Now()
+	_, err := NewStatusToken(nil, "env", "node", now, now.Add(time.Hour))
+	assert.Error(t, err)
+	_, err = NewStatusToken(key, "", "node", now, now.Add(time.Hour))
+	assert.Error(t, err)
+	_, err = NewStatusToken(key, "env", "no|de", now, now.Add(time.Hour))
+	assert.Error(t, err)
+	_, err = NewStatusToken(key, "env", "node", now, now)
+	assert.Error(t, err)
+}
+
+func TestStatusOfNode(t *testing.T) {
+	now := time.Now()
+	n := OsqueryNode{
+		Model:          gorm.Model{CreatedAt: now.Add(-48 * time.Hour)},
+		UUID:           "node-uuid",
+		Hostname:       "host",
+		OsqueryVersion: "5.2.2",
+	}
+	status := StatusOfNode(n, "dev", now.Add(-time.Hour), 24)
+	assert.Equal(t, "host", status.Hostname)
+	assert.Equal(t, "dev", status.Environment)
+	assert.Equal(t, StatusEnrolled, status.Enrollment)
+	assert.Equal(t, ConfigNever, status.ConfigSync)
+	n.LastConfig = now.Add(-2 * time.Hour)
+	assert.Equal(t, ConfigPending, StatusOfNode(n, "dev", now.Add(-time.Hour), 24).ConfigSync)
+	n.LastConfig = now
+	assert.Equal(t, ConfigSynced, StatusOfNode(n, "dev", now.Add(-time.Hour), 24).ConfigSync)
+}
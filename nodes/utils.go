@@ -1,7 +1,9 @@
 package nodes
 
 import (
+	"encoding/json"
 	"math"
+	"strings"
 	"time"
 )
 
@@ -40,3 +42,21 @@ func IsActive(n OsqueryNode, inactive int64) bool {
 	}
 	return false
 }
+
+// HardwareModel to get the hardware model of a node from the system_info sent when it enrolled
+func HardwareModel(n OsqueryNode) string {
+	if n.RawEnrollment == "" {
+		return ""
+	}
+	var enroll struct {
+		HostDetails struct {
+			SystemInfo struct {
+				HardwareModel string `json:"hardware_model"`
+			} `json:"system_info"`
+		} `json:"host_details"`
+	}
+	if err := json.Unmarshal([]byte(n.RawEnrollment), &enroll); err != nil {
+		return ""
+	}
+	return strings.TrimSpace(enroll.HostDetails.SystemInfo.HardwareModel)
+}
@@ -0,0 +1,57 @@
+package nodes
+
+import (
+	"fmt"
+	"sort"
+)
+
+// VersionCount to count the nodes of an environment running an osquery version in a platform
+type VersionCount struct {
+	Platform string `json:"platform"`
+	Version  string `json:"version"`
+	Pinned   string `json:"pinned"`
+	OffPin   bool   `json:"off_pin"`
+	Nodes    int64  `json:"nodes"`
+}
+
+// VersionReport to expose the osquery versions of the nodes of an environment against the pinned versions
+type VersionReport struct {
+	Environment string         `json:"environment"`
+	Nodes       int64          `json:"nodes"`
+	OffPin      int64          `json:"off_pin"`
+	Versions    []VersionCount `json:"versions"`
+}
+
+// VersionReportOf to get the report of a list of counts, with the version pinned for each platform.
+// Nodes that did not report a version yet are never off-pin.
+func VersionReportOf(counts []VersionCount, pinned func(platform string) string) VersionReport {
+	report := VersionReport{Versions: []VersionCount{}}
+	for _, c := range counts {
+		c.Pinned = pinned(c.Platform)
+		c.OffPin = c.Pinned != "" && c.Version != "" && c.Version != c.Pinned
+		report.Nodes += c.Nodes
+		if c.OffPin {
+			report.OffPin += c.Nodes
+		}
+		report.Versions = append(report.Versions, c)
+	}
+	sort.SliceStable(report.Versions, func(i, j int) bool {
+		if report.Versions[i].Nodes != report.Versions[j].Nodes {
+			return report.Versions[i].Nodes > report.Versions[j].Nodes
+		}
+		if report.Versions[i].Platform != report.Versions[j].Platform {
+			return report.Versions[i].Platform < report.Versions[j].Platform
+		}
+		return report.Versions[i].Version < report.Versions[j].Version
+	})
+	return report
+}
+
+// GetVersionReport to get the osquery versions of the nodes of an environment by platform, with the version pinned for each platform
+func (n *NodeManager) GetVersionReport(envid uint, pinned func(platform string) string) (VersionReport, error) {
+	var counts []VersionCount
+	if err := n.read().Model(&OsqueryNode{}).Select("platform, osquery_version as version, count(*) as nodes").Where("environment_id = ?", envid).Group("platform, osquery_version").Scan(&counts).Error; err != nil {
+		return VersionReport{Versions: []VersionCount{}}, fmt.Errorf("Group %v", err)
+	}
+	return VersionReportOf(counts, pinned), nil
+}
@@ -0,0 +1,31 @@
+package nodes
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVersionReportOf(t *testing.T) {
+	pins := map[string]string{"darwin": "5.6.0", "ubuntu": "5.5.1"}
+	pinned := func(platform string) string {
+		return pins[platform]
+	}
+	counts := []VersionCount{
+		{Platform: "ubuntu", Version: "5.5.1", Nodes: 10},
+		{Platform: "darwin", Version: "5.4.0", Nodes: 3},
+		{Platform: "darwin", Version: "5.6.0", Nodes: 10},
+		{Platform: "windows", Version: "5.2.0", Nodes: 4},
+		{Platform: "ubuntu", Version: "", Nodes: 1},
+	}
+	report := VersionReportOf(counts, pinned)
+	assert.Equal(t, int64(28), report.Nodes)
+	assert.Equal(t, int64(3), report.OffPin)
+	assert.Equal(t, VersionCount{Platform: "darwin", Version: "5.6.0", Pinned: "5.6.0", Nodes: 10}, report.Versions[0])
+	assert.Equal(t, VersionCount{Platform: "darwin", Version: "5.4.0", Pinned: "5.6.0", OffPin: true, Nodes: 3}, report.Versions[3])
+	assert.False(t, report.Versions[2].OffPin)
+	assert.Equal(t, "windows", report.Versions[2].Platform)
+	empty := VersionReportOf(nil, pinned)
+	assert.NotNil(t, empty.Versions)
+	assert.Equal(t, int64(0), empty.Nodes)
+}
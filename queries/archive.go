@@ -0,0 +1,629 @@
+package queries
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/jmpsec/osctrl/backend"
+	"gorm.io/gorm"
+)
+
+const (
+	// ArchiveArchived for queries with the results only in the archive
+	ArchiveArchived string = "archived"
+	// ArchiveRehydrating for archived queries with the results being restored
+	ArchiveRehydrating string = "rehydrating"
+	// ArchiveRehydrated for archived queries with the results restored until they are evicted
+	ArchiveRehydrated string = "rehydrated"
+	// ArchiveDeleted for archives of deleted queries, waiting for the objects to be removed
+	ArchiveDeleted string = "deleted"
+	// ArchiveActor is who archives and evicts queries in the activity of environments
+	ArchiveActor string = "archiver"
+	// DefaultArchiveDays is the days after which queries are archived, 0 does not archive them
+	DefaultArchiveDays int = 0
+	// DefaultRehydrateHours is the hours the results of archived queries are kept after they are rehydrated
+	DefaultRehydrateHours int = 72
+	// DefaultArchiveInterval is how often queries are archived, rehydrated and evicted
+	DefaultArchiveInterval = time.Minute
+	// archiveBatch is the number of rows read and restored at once
+	archiveBatch int = 1000
+	// archiveQueries is the number of queries archived in each run
+	archiveQueries int = 50
+)
+
+// ErrArchiveIntegrity when an archived object does not match the hash recorded when it was archived
+var ErrArchiveIntegrity = errors.New("archived object does not match its hash")
+
+// QueryArchive to keep where the results of an archived query are, with their hashes and the rehydration progress
+type QueryArchive struct {
+	gorm.Model
+	Name               string `gorm:"index"`
+	EnvironmentID      uint   `gorm:"index"`
+	State              string `gorm:"index"`
+	Storage            string
+	Location           string `json:"-"`
+	Rows               int
+	Size               int64
+	SHA256             string
+	ExecutionsLocation string `json:"-"`
+	Executions         int
+	ExecutionsSize     int64
+	ExecutionsSHA256   string
+	Restored           int
+	Error              string
+	RehydratedBy       string
+	RehydratedAt       time.Time
+	EvictAt            time.Time
+}
+
+// ArchivePolicy to decide which queries are archived and for how long rehydrated results are kept
+type ArchivePolicy struct {
+	Days       int
+	Executions bool
+	TTL        time.Duration
+}
+
+// ArchivedResult to read and restore the results of queries, kept in the same table the logger writes them
+type ArchivedResult struct {
+	gorm.Model
+	UUID        string `gorm:"index"`
+	Environment string
+	Name        string
+	Data        string
+	Status      int
+}
+
+// TableName of the results of queries
+func (ArchivedResult) TableName() string {
+	return "osquery_query_data"
+}
+
+// archivedResult is one line of archived results
+type archivedResult struct {
+	UUID        string    `json:"uuid"`
+	Environment string    `json:"environment"`
+	Name        string    `json:"name"`
+	Data        string    `json:"data"`
+	Status      int       `json:"status"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// archivedExecution is one line of archived executions
+type archivedExecution struct {
+	Name      string    `json:"name"`
+	UUID      string    `json:"uuid"`
+	Result    int       `json:"result"`
+	Hash      string    `json:"hash"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// NewArchivePolicy to archive queries after the days, keeping rehydrated results for the hours
+func NewArchivePolicy(days int, executions bool, hours int) ArchivePolicy {
+	if hours <= 0 {
+		hours = DefaultRehydrateHours
+	}
+	return ArchivePolicy{Days: days, Executions: executions, TTL: time.Duration(hours) * time.Hour}
+}
+
+// Progress of the rehydration of an archive, in percent
+func (a QueryArchive) Progress() int {
+	total := a.Rows + a.Executions
+	if a.State == ArchiveRehydrated || total == 0 {
+		return 100
+	}
+	if a.State != ArchiveRehydrating {
+		return 0
+	}
+	return a.Restored * 100 / total
+}
+
+// Available to check if the results of an archived query are in the hot tables
+func (a QueryArchive) Available() bool {
+	return a.State == ArchiveRehydrated
+}
+
+// ArchiveSummary to describe a change of an archive for the activity of the environment
+func ArchiveSummary(a QueryArchive) string {
+	switch a.State {
+	case ArchiveRehydrating:
+		return fmt.Sprintf("rehydration of query %s requested", a.Name)
+	case ArchiveRehydrated:
+		return fmt.Sprintf("query %s rehydrated with %d results until %s", a.Name, a.Rows, a.EvictAt.Format(time.RFC3339))
+	}
+	if a.Error != "" {
+		return fmt.Sprintf("rehydration of query %s failed: %s", a.Name, a.Error)
+	}
+	if !a.RehydratedAt.IsZero() {
+		return fmt.Sprintf("rehydrated results of query %s evicted", a.Name)
+	}
+	return fmt.Sprintf("query %s archived with %d results and %d executions in %s", a.Name, a.Rows, a.Executions, a.Storage)
+}
+
+// Helper to get the key of an archived object of a query
+func archiveKey(name, kind string) string {
+	return fmt.Sprintf("%s-%s.jsonl.gz", name, kind)
+}
+
+// Helper to write lines of JSON compressed with gzip in a file of the directory, keeping the size and the hash of
+// the compressed file as it is stored
+func writeArchive(dir string, lines func(enc *json.Encoder) (int, error)) (string, ExportArtifact, error) {
+	f, err := os.CreateTemp(dir, "archive-*.jsonl.gz")
+	if err != nil {
+		return "", ExportArtifact{}, err
+	}
+	hash := sha256.New()
+	counter := &countWriter{}
+	gz := gzip.NewWriter(io.MultiWriter(f, hash, counter))
+	written, err := lines(json.NewEncoder(gz))
+	if err == nil {
+		err = gz.Close()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", ExportArtifact{}, err
+	}
+	return f.Name(), ExportArtifact{Rows: written, Size: counter.n, SHA256: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+// Helper to download an archived object to a file of the directory, verifying its hash before it is read
+func readArchive(ctx context.Context, store ExportStore, location string, size int64, sum string) (string, error) {
+	r, err := store.Open(ctx, location, size)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	f, err := os.CreateTemp(store.Dir(), "rehydrate-*.jsonl.gz")
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, hash), r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && hex.EncodeToString(hash.Sum(nil)) != sum {
+		err = ErrArchiveIntegrity
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// Helper to read the lines of JSON of a downloaded archive, calling fn with each batch of lines
+func decodeArchive(file string, line func() interface{}, fn func(batch []interface{}) error) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	dec := json.NewDecoder(gz)
+	batch := make([]interface{}, 0, archiveBatch)
+	for {
+		l := line()
+		if err := dec.Decode(l); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if batch = append(batch, l); len(batch) == archiveBatch {
+			if err := fn(batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	if len(batch) > 0 {
+		return fn(batch)
+	}
+	return nil
+}
+
+// WriteArchivedResults to write the results of a query as lines of JSON, returning how many were written
+func (q *Queries) WriteArchivedResults(name string, enc *json.Encoder) (int, error) {
+	written := 0
+	var batch []ArchivedResult
+	err := q.DB.Where("name = ?", name).Order("id").FindInBatches(&batch, archiveBatch, func(tx *gorm.DB, n int) error {
+		for _, r := range batch {
+			if err := enc.Encode(archivedResult{UUID: r.UUID, Environment: r.Environment, Name: r.Name, Data: r.Data, Status: r.Status, CreatedAt: r.CreatedAt}); err != nil {
+				return err
+			}
+			written++
+		}
+		return nil
+	}).Error
+	return written, err
+}
+
+// WriteArchivedExecutions to write the executions of a query in each node as lines of JSON, returning how many were written
+func (q *Queries) WriteArchivedExecutions(name string, enc *json.Encoder) (int, error) {
+	written := 0
+	var batch []DistributedQueryExecution
+	err := q.DB.Where("name = ?", name).Order("id").FindInBatches(&batch, archiveBatch, func(tx *gorm.DB, n int) error {
+		for _, e := range batch {
+			if err := enc.Encode(archivedExecution{Name: e.Name, UUID: e.UUID, Result: e.Result, Hash: e.Hash, CreatedAt: e.CreatedAt}); err != nil {
+				return err
+			}
+			written++
+		}
+		return nil
+	}).Error
+	return written, err
+}
+
+// Helper to write and save one archived object of a query
+func (q *Queries) saveArchive(ctx context.Context, store ExportStore, key string, lines func(enc *json.Encoder) (int, error)) (string, ExportArtifact, error) {
+	file, artifact, err := writeArchive(store.Dir(), lines)
+	if err != nil {
+		return "", artifact, err
+	}
+	defer os.Remove(file)
+	location, err := store.Save(ctx, key, file)
+	return location, artifact, err
+}
+
+// Helper to delete the archived rows of a query from the hot tables
+func deleteArchivedRows(tx *gorm.DB, a QueryArchive) error {
+	if err := tx.Unscoped().Where("name = ?", a.Name).Delete(&ArchivedResult{}).Error; err != nil {
+		return fmt.Errorf("Delete %v", err)
+	}
+	if a.ExecutionsLocation != "" {
+		if err := tx.Unscoped().Where("name = ?", a.Name).Delete(&DistributedQueryExecution{}).Error; err != nil {
+			return fmt.Errorf("Delete %v", err)
+		}
+	}
+	return nil
+}
+
+// ArchiveCandidates to get the completed or expired queries created before the cutoff that are not archived yet
+func (q *Queries) ArchiveCandidates(cutoff, now time.Time, limit int) ([]DistributedQuery, error) {
+	var candidates []DistributedQuery
+	if err := q.DB.Where(
+		"type = ? AND archived = ? AND deleted = ? AND created_at < ? AND (completed = ? OR (expires_at > ? AND expires_at <= ?))",
+		StandardQueryType, false, false, cutoff, true, time.Time{}, now,
+	).Order("id").Limit(limit).Find(&candidates).Error; err != nil {
+		return candidates, err
+	}
+	return candidates, nil
+}
+
+// ArchiveQuery to move the results of a query, and optionally its executions, to compressed objects in the store.
+// The rows are removed from the hot tables and the query is marked as archived pointing to the archive.
+func (q *Queries) ArchiveQuery(ctx context.Context, store ExportStore, query DistributedQuery, executions bool) (QueryArchive, error) {
+	archive := QueryArchive{
+		Name:          query.Name,
+		EnvironmentID: query.EnvironmentID,
+		State:         ArchiveArchived,
+		Storage:       store.Name(),
+	}
+	location, artifact, err := q.saveArchive(ctx, store, archiveKey(query.Name, "results"), func(enc *json.Encoder) (int, error) {
+		return q.WriteArchivedResults(query.Name, enc)
+	})
+	if err != nil {
+		return archive, fmt.Errorf("error archiving results %v", err)
+	}
+	archive.Location = location
+	archive.Rows = artifact.Rows
+	archive.Size = artifact.Size
+	archive.SHA256 = artifact.SHA256
+	if executions {
+		location, artifact, err := q.saveArchive(ctx, store, archiveKey(query.Name, "executions"), func(enc *json.Encoder) (int, error) {
+			return q.WriteArchivedExecutions(query.Name, enc)
+		})
+		if err != nil {
+			_ = store.Remove(ctx, archive.Location)
+			return archive, fmt.Errorf("error archiving executions %v", err)
+		}
+		archive.ExecutionsLocation = location
+		archive.Executions = artifact.Rows
+		archive.ExecutionsSize = artifact.Size
+		archive.ExecutionsSHA256 = artifact.SHA256
+	}
+	if err := q.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&archive).Error; err != nil {
+			return fmt.Errorf("Create %v", err)
+		}
+		if err := deleteArchivedRows(tx, archive); err != nil {
+			return err
+		}
+		return tx.Model(&DistributedQuery{}).Where("id = ?", query.ID).Updates(map[string]interface{}{"archived": true, "archive_id": archive.ID}).Error
+	}); err != nil {
+		for _, l := range []string{archive.Location, archive.ExecutionsLocation} {
+			if l != "" {
+				_ = store.Remove(ctx, l)
+			}
+		}
+		return archive, err
+	}
+	return archive, nil
+}
+
+// GetArchive to get the archive of a query in an environment
+func (q *Queries) GetArchive(name string, envid uint) (QueryArchive, error) {
+	var archive QueryArchive
+	if err := q.DB.Where("name = ? AND environment_id = ? AND state <> ?", name, envid, ArchiveDeleted).First(&archive).Error; err != nil {
+		return archive, err
+	}
+	return archive, nil
+}
+
+// GetArchives to get the archives of queries in an environment, newest first
+func (q *Queries) GetArchives(envid uint) ([]QueryArchive, error) {
+	var archives []QueryArchive
+	if err := q.DB.Where("environment_id = ? AND state <> ?", envid, ArchiveDeleted).Order("id DESC").Find(&archives).Error; err != nil {
+		return archives, err
+	}
+	return archives, nil
+}
+
+// RequestRehydrate to request the results of an archived query to be restored, it happens in the background
+func (q *Queries) RequestRehydrate(name string, envid uint, user string) (QueryArchive, error) {
+	archive, err := q.GetArchive(name, envid)
+	if err != nil {
+		return archive, err
+	}
+	if archive.State != ArchiveArchived {
+		return archive, fmt.Errorf("query %s is %s", name, archive.State)
+	}
+	updates := map[string]interface{}{"state": ArchiveRehydrating, "restored": 0, "error": "", "rehydrated_by": user}
+	if err := q.DB.Model(&QueryArchive{}).Where("id = ? AND state = ?", archive.ID, ArchiveArchived).Updates(updates).Error; err != nil {
+		return archive, fmt.Errorf("Updates %v", err)
+	}
+	archive.State = ArchiveRehydrating
+	archive.Restored = 0
+	archive.Error = ""
+	archive.RehydratedBy = user
+	return archive, nil
+}
+
+// RehydrateArchive to restore the results of an archived query after verifying the hashes of the objects, they are
+// kept until the TTL and evicted again. Failed rehydrations go back to archived with the error.
+func (q *Queries) RehydrateArchive(ctx context.Context, store ExportStore, archive QueryArchive, ttl time.Duration) (QueryArchive, error) {
+	if err := q.rehydrate(ctx, store, archive); err != nil {
+		// Anything restored before the error is removed, so it can be requested again
+		_ = deleteArchivedRows(q.DB, archive)
+		archive.State = ArchiveArchived
+		archive.Error = err.Error()
+		if uerr := q.DB.Model(&QueryArchive{}).Where("id = ?", archive.ID).Updates(map[string]interface{}{"state": archive.State, "error": archive.Error, "restored": 0}).Error; uerr != nil {
+			log.Printf("error updating archive of %s %v", archive.Name, uerr)
+		}
+		return archive, err
+	}
+	now := time.Now()
+	archive.State = ArchiveRehydrated
+	archive.Restored = archive.Rows + archive.Executions
+	archive.RehydratedAt = now
+	archive.EvictAt = now.Add(ttl)
+	if err := q.DB.Model(&QueryArchive{}).Where("id = ?", archive.ID).Updates(map[string]interface{}{
+		"state":         archive.State,
+		"restored":      archive.Restored,
+		"rehydrated_at": archive.RehydratedAt,
+		"evict_at":      archive.EvictAt,
+	}).Error; err != nil {
+		return archive, fmt.Errorf("Updates %v", err)
+	}
+	return archive, nil
+}
+
+// Helper to restore the rows of the objects of an archive, keeping the progress as they are restored
+func (q *Queries) rehydrate(ctx context.Context, store ExportStore, archive QueryArchive) error {
+	// Rows of an interrupted rehydration are removed before restoring them again
+	if err := deleteArchivedRows(q.DB, archive); err != nil {
+		return err
+	}
+	restored := 0
+	progress := func(n int) error {
+		restored += n
+		return q.DB.Model(&QueryArchive{}).Where("id = ?", archive.ID).Update("restored", restored).Error
+	}
+	file, err := readArchive(ctx, store, archive.Location, archive.Size, archive.SHA256)
+	if err != nil {
+		return fmt.Errorf("error reading results %v", err)
+	}
+	defer os.Remove(file)
+	if err := decodeArchive(file, func() interface{} { return &archivedResult{} }, func(batch []interface{}) error {
+		rows := make([]ArchivedResult, 0, len(batch))
+		for _, l := range batch {
+			r := l.(*archivedResult)
+			row := ArchivedResult{UUID: r.UUID, Environment: r.Environment, Name: r.Name, Data: r.Data, Status: r.Status}
+			row.CreatedAt = r.CreatedAt
+			rows = append(rows, row)
+		}
+		if err := q.DB.Create(&rows).Error; err != nil {
+			return fmt.Errorf("Create %v", err)
+		}
+		return progress(len(rows))
+	}); err != nil {
+		return err
+	}
+	if archive.ExecutionsLocation == "" {
+		return nil
+	}
+	file, err = readArchive(ctx, store, archive.ExecutionsLocation, archive.ExecutionsSize, archive.ExecutionsSHA256)
+	if err != nil {
+		return fmt.Errorf("error reading executions %v", err)
+	}
+	defer os.Remove(file)
+	return decodeArchive(file, func() interface{} { return &archivedExecution{} }, func(batch []interface{}) error {
+		rows := make([]DistributedQueryExecution, 0, len(batch))
+		for _, l := range batch {
+			e := l.(*archivedExecution)
+			row := DistributedQueryExecution{Name: e.Name, UUID: e.UUID, Result: e.Result, Hash: e.Hash}
+			row.CreatedAt = e.CreatedAt
+			rows = append(rows, row)
+		}
+		if err := q.DB.Create(&rows).Error; err != nil {
+			return fmt.Errorf("Create %v", err)
+		}
+		return progress(len(rows))
+	})
+}
+
+// EvictArchive to remove the rehydrated results of an archived query from the hot tables again
+func (q *Queries) EvictArchive(archive QueryArchive) (QueryArchive, error) {
+	err := q.DB.Transaction(func(tx *gorm.DB) error {
+		if err := deleteArchivedRows(tx, archive); err != nil {
+			return err
+		}
+		return tx.Model(&QueryArchive{}).Where("id = ?", archive.ID).Updates(map[string]interface{}{"state": ArchiveArchived, "restored": 0, "evict_at": time.Time{}}).Error
+	})
+	if err != nil {
+		return archive, err
+	}
+	archive.State = ArchiveArchived
+	archive.Restored = 0
+	archive.EvictAt = time.Time{}
+	return archive, nil
+}
+
+// Helper to get the archives in one state
+func (q *Queries) archivesIn(state string) ([]QueryArchive, error) {
+	var archives []QueryArchive
+	if err := q.DB.Where("state = ?", state).Order("id").Find(&archives).Error; err != nil {
+		return archives, err
+	}
+	return archives, nil
+}
+
+// RemoveDeletedArchives to remove the objects of archives of deleted queries, the archives are removed with them
+func (q *Queries) RemoveDeletedArchives(ctx context.Context, store ExportStore) (int, error) {
+	deleted, err := q.archivesIn(ArchiveDeleted)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, a := range deleted {
+		if err := removeArchiveObjects(ctx, store, a); err != nil {
+			log.Printf("error removing archive of %s %v", a.Name, err)
+			continue
+		}
+		if err := q.DB.Unscoped().Delete(&a).Error; err != nil {
+			return removed, fmt.Errorf("Delete %v", err)
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// Helper to remove the objects of an archive from the store
+func removeArchiveObjects(ctx context.Context, store ExportStore, a QueryArchive) error {
+	for _, l := range []string{a.Location, a.ExecutionsLocation} {
+		if l == "" {
+			continue
+		}
+		if err := store.Remove(ctx, l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Helper to mark the archives of queries deleted permanently, their objects are removed in the background
+func deleteArchives(tx *gorm.DB, names []string) error {
+	if err := tx.Model(&QueryArchive{}).Where("name IN ?", names).Update("state", ArchiveDeleted).Error; err != nil {
+		return fmt.Errorf("Update %v", err)
+	}
+	return nil
+}
+
+// RunArchiving to remove the objects of deleted queries, rehydrate and evict archived queries and archive a batch of
+// queries old enough. Each archive that changes is passed to record.
+func (q *Queries) RunArchiving(ctx context.Context, store ExportStore, policy ArchivePolicy, now time.Time, record func(QueryArchive)) error {
+	if _, err := q.RemoveDeletedArchives(ctx, store); err != nil {
+		return err
+	}
+	rehydrating, err := q.archivesIn(ArchiveRehydrating)
+	if err != nil {
+		return err
+	}
+	for _, a := range rehydrating {
+		a, err := q.RehydrateArchive(ctx, store, a, policy.TTL)
+		if err != nil {
+			log.Printf("error rehydrating query %s %v", a.Name, err)
+		}
+		record(a)
+	}
+	rehydrated, err := q.archivesIn(ArchiveRehydrated)
+	if err != nil {
+		return err
+	}
+	for _, a := range rehydrated {
+		if now.Before(a.EvictAt) {
+			continue
+		}
+		a, err := q.EvictArchive(a)
+		if err != nil {
+			return err
+		}
+		record(a)
+	}
+	if policy.Days <= 0 {
+		return nil
+	}
+	candidates, err := q.ArchiveCandidates(now.AddDate(0, 0, -policy.Days), now, archiveQueries)
+	if err != nil {
+		return err
+	}
+	for _, c := range candidates {
+		a, err := q.ArchiveQuery(ctx, store, c, policy.Executions)
+		if err != nil {
+			log.Printf("error archiving query %s %v", c.Name, err)
+			continue
+		}
+		record(a)
+	}
+	return nil
+}
+
+// StartArchiving to archive, rehydrate and evict queries periodically with a leader lock, until ctx is cancelled
+func (q *Queries) StartArchiving(ctx context.Context, leader *backend.LeaderLock, interval time.Duration, store ExportStore, policy func() ArchivePolicy, record func(QueryArchive)) {
+	if interval <= 0 {
+		interval = DefaultArchiveInterval
+	}
+	run := func() {
+		if leader != nil {
+			isLeader, err := leader.Acquire()
+			if err != nil {
+				log.Printf("error acquiring leader lock %v", err)
+				return
+			}
+			if !isLeader {
+				return
+			}
+		}
+		if err := q.RunArchiving(ctx, store, policy(), time.Now(), record); err != nil {
+			log.Printf("error archiving queries %v", err)
+		}
+	}
+	go func() {
+		run()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				run()
+			}
+		}
+	}()
+}
@@ -0,0 +1,101 @@
+package queries
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper to write an archive of results in a local store for tests
+func testArchive(t *testing.T, count int) (*LocalExportStore, string, ExportArtifact) {
+	store, err := CreateLocalExportStore(t.TempDir())
+	assert.NoError(t, err)
+	collected := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	file, artifact, err := writeArchive(store.Dir(), func(enc *json.Encoder) (int, error) {
+		for i := 0; i < count; i++ {
+			if err := enc.Encode(archivedResult{UUID: "AAAA-1111", Name: "query", Data: `[{"pid":"1"}]`, CreatedAt: collected}); err != nil {
+				return i, err
+			}
+		}
+		return count, nil
+	})
+	assert.NoError(t, err)
+	location, err := store.Save(context.Background(), archiveKey("query", "results"), file)
+	assert.NoError(t, err)
+	return store, location, artifact
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	store, location, artifact := testArchive(t, archiveBatch+5)
+	assert.Equal(t, archiveBatch+5, artifact.Rows)
+	file, err := readArchive(context.Background(), store, location, artifact.Size, artifact.SHA256)
+	assert.NoError(t, err)
+	var batches []int
+	restored := 0
+	err = decodeArchive(file, func() interface{} { return &archivedResult{} }, func(batch []interface{}) error {
+		batches = append(batches, len(batch))
+		for _, l := range batch {
+			r := l.(*archivedResult)
+			assert.Equal(t, "AAAA-1111", r.UUID)
+			assert.Equal(t, `[{"pid":"1"}]`, r.Data)
+			restored++
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{archiveBatch, 5}, batches)
+	assert.Equal(t, artifact.Rows, restored)
+}
+
+func TestArchiveIntegrity(t *testing.T) {
+	store, location, artifact := testArchive(t, 3)
+	t.Run("hash mismatch", func(t *testing.T) {
+		_, err := readArchive(context.Background(), store, location, artifact.Size, "0000")
+		assert.ErrorIs(t, err, ErrArchiveIntegrity)
+	})
+	t.Run("object changed", func(t *testing.T) {
+		assert.NoError(t, os.WriteFile(location, []byte("tampered"), 0600))
+		_, err := readArchive(context.Background(), store, location, artifact.Size, artifact.SHA256)
+		assert.ErrorIs(t, err, ErrArchiveIntegrity)
+	})
+	t.Run("object removed", func(t *testing.T) {
+		assert.NoError(t, removeArchiveObjects(context.Background(), store, QueryArchive{Location: location}))
+		_, err := readArchive(context.Background(), store, location, artifact.Size, artifact.SHA256)
+		assert.Error(t, err)
+	})
+}
+
+func TestArchiveProgress(t *testing.T) {
+	a := QueryArchive{Name: "query", Rows: 150, Executions: 50, State: ArchiveRehydrating, Restored: 50}
+	assert.Equal(t, 25, a.Progress())
+	a.State = ArchiveArchived
+	assert.Equal(t, 0, a.Progress())
+	a.State = ArchiveRehydrated
+	assert.Equal(t, 100, a.Progress())
+	assert.True(t, a.Available())
+}
+
+func TestArchiveSummary(t *testing.T) {
+	a := QueryArchive{Name: "query", Rows: 3, Storage: ExportStorageS3, State: ArchiveArchived}
+	assert.Equal(t, "query query archived with 3 results and 0 executions in s3", ArchiveSummary(a))
+	a.State = ArchiveRehydrating
+	assert.Equal(t, "rehydration of query query requested", ArchiveSummary(a))
+	a.State = ArchiveArchived
+	a.Error = ErrArchiveIntegrity.Error()
+	assert.Equal(t, "rehydration of query query failed: archived object does not match its hash", ArchiveSummary(a))
+	a.Error = ""
+	a.RehydratedAt = time.Now()
+	assert.Equal(t, "rehydrated results of query query evicted", ArchiveSummary(a))
+}
+
+func TestNewArchivePolicy(t *testing.T) {
+	assert.Equal(t, time.Duration(DefaultRehydrateHours)*time.Hour, NewArchivePolicy(30, false, 0).TTL)
+	p := NewArchivePolicy(30, true, 2)
+	assert.Equal(t, 30, p.Days)
+	assert.True(t, p.Executions)
+	assert.Equal(t, 2*time.Hour, p.TTL)
+}
@@ -0,0 +1,55 @@
+package queries
+
+import (
+	"strings"
+
+	"github.com/jmpsec/osctrl/nodes"
+)
+
+// QueryTargetAttribute defines a filter by custom attributes as target, like vuln.risk_score>7
+const QueryTargetAttribute string = "attribute"
+
+// ParseAttributeTargets to validate the attribute filters used as targets, empty values are ignored
+func ParseAttributeTargets(values []string) ([]nodes.AttributeFilter, error) {
+	var filters []nodes.AttributeFilter
+	for _, v := range values {
+		if strings.TrimSpace(v) == "" {
+			continue
+		}
+		f, err := nodes.ParseAttributeFilter(v)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
+	}
+	return filters, nil
+}
+
+// Helper to get the SQL conditions for the nodes matching the attribute targets
+func attributeConditions(targets []DistributedQueryTarget) ([]string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	for _, t := range targets {
+		if t.Type != QueryTargetAttribute {
+			continue
+		}
+		f, err := nodes.ParseAttributeFilter(t.Value)
+		if err != nil {
+			continue
+		}
+		condition, fArgs := f.NodesCondition()
+		conditions = append(conditions, condition)
+		args = append(args, fArgs...)
+	}
+	return conditions, args
+}
+
+// CreateAttributeTargets to create the targets of a query for attribute filters, returns how many were created
+func (q *Queries) CreateAttributeTargets(name string, filters []nodes.AttributeFilter) (int, error) {
+	for _, f := range filters {
+		if err := q.CreateTarget(name, QueryTargetAttribute, f.String()); err != nil {
+			return 0, err
+		}
+	}
+	return len(filters), nil
+}
@@ -0,0 +1,27 @@
+package queries
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAttributeTargets(t *testing.T) {
+	filters, err := ParseAttributeTargets([]string{"vuln.risk_score>7", " ", "attr:cmdb.owner=alice"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(filters))
+	assert.Equal(t, "cmdb.owner", filters[1].Name)
+	_, err = ParseAttributeTargets([]string{"owner=alice"})
+	assert.Error(t, err)
+}
+
+func TestTargetsConditionAttributes(t *testing.T) {
+	targets := []DistributedQueryTarget{
+		{Type: QueryTargetPlatform, Value: "darwin"},
+		{Type: QueryTargetAttribute, Value: "cmdb.owner=alice"},
+	}
+	condition, args := targetsCondition(targets)
+	assert.Equal(t, "(platform IN ? OR id IN (SELECT node_id FROM node_attributes WHERE deleted_at IS NULL AND name = ? AND ((type = 'string' AND lower(string_value) = ?))))", condition)
+	assert.Equal(t, []string{"darwin"}, args[0])
+	assert.Equal(t, "cmdb.owner", args[1])
+}
@@ -0,0 +1,287 @@
+package queries
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// MaxQueryLabels is the maximum number of labels of one query
+	MaxQueryLabels int = 20
+	// MaxLabelKey is the maximum length of the key of a label
+	MaxLabelKey int = 64
+	// MaxLabelValue is the maximum length of the value of a label
+	MaxLabelValue int = 256
+	// MaxQueryDescription is the maximum length of the description of a query
+	MaxQueryDescription int = 4096
+	// MaxTicketID is the maximum length of the ticket ID of a query
+	MaxTicketID int = 128
+	// MaxTicketURL is the maximum length of the ticket URL of a query
+	MaxTicketURL int = 2048
+)
+
+// Keys of labels are identifiers like team, case or mitre.technique
+var labelKeyRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.:/-]*$`)
+
+// DistributedQueryLabel to keep the labels of queries, indexed by key and value to filter queries
+type DistributedQueryLabel struct {
+	gorm.Model
+	Name  string `gorm:"index"`
+	Key   string `gorm:"index:idx_query_label"`
+	Value string `gorm:"index:idx_query_label"`
+}
+
+// QueryContext to hold the investigation context of a query: why it was run and which ticket it belongs to
+type QueryContext struct {
+	Description string            `json:"description"`
+	TicketID    string            `json:"ticket_id"`
+	TicketURL   string            `json:"ticket_url"`
+	Labels      map[string]string `json:"labels"`
+}
+
+// ContextFilter to select queries by label and ticket, zero values match everything.
+// Labels without value match any value of the key.
+type ContextFilter struct {
+	LabelKey   string
+	LabelValue string
+	Ticket     string
+}
+
+// ContextOf to get the investigation context of a query, labels must be loaded
+func ContextOf(query DistributedQuery) QueryContext {
+	return QueryContext{
+		Description: query.Description,
+		TicketID:    query.TicketID,
+		TicketURL:   query.TicketURL,
+		Labels:      query.Labels,
+	}
+}
+
+// Empty to check if there is no context at all
+func (c QueryContext) Empty() bool {
+	return c.Description == "" && c.TicketID == "" && c.TicketURL == "" && len(c.Labels) == 0
+}
+
+// Empty to check if the filter matches every query
+func (f ContextFilter) Empty() bool {
+	return f.LabelKey == "" && f.Ticket == ""
+}
+
+// ValidateContext to check the investigation context of a query against the caps of labels and fields
+func ValidateContext(c QueryContext) error {
+	if len(c.Description) > MaxQueryDescription {
+		return fmt.Errorf("description is longer than %d characters", MaxQueryDescription)
+	}
+	if len(c.TicketID) > MaxTicketID {
+		return fmt.Errorf("ticket ID is longer than %d characters", MaxTicketID)
+	}
+	if c.TicketURL != "" {
+		if len(c.TicketURL) > MaxTicketURL {
+			return fmt.Errorf("ticket URL is longer than %d characters", MaxTicketURL)
+		}
+		u, err := url.Parse(c.TicketURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("ticket URL %s must be a http or https URL", c.TicketURL)
+		}
+	}
+	if len(c.Labels) > MaxQueryLabels {
+		return fmt.Errorf("queries can have up to %d labels", MaxQueryLabels)
+	}
+	for k, v := range c.Labels {
+		if len(k) > MaxLabelKey || !labelKeyRe.MatchString(k) {
+			return fmt.Errorf("invalid label key %q, use up to %d letters, digits and _ . : / -", k, MaxLabelKey)
+		}
+		if len(v) > MaxLabelValue {
+			return fmt.Errorf("value of label %s is longer than %d characters", k, MaxLabelValue)
+		}
+	}
+	return nil
+}
+
+// ParseLabels to parse labels as key=value, empty entries are skipped
+func ParseLabels(specs []string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		i := strings.Index(spec, "=")
+		if i <= 0 {
+			return labels, fmt.Errorf("invalid label %q, use key=value", spec)
+		}
+		labels[strings.TrimSpace(spec[:i])] = strings.TrimSpace(spec[i+1:])
+	}
+	return labels, nil
+}
+
+// ParseContextFilter to get the filter of queries from a label as key=value or key, and a ticket ID or URL
+func ParseContextFilter(label, ticket string) ContextFilter {
+	f := ContextFilter{Ticket: strings.TrimSpace(ticket)}
+	label = strings.TrimSpace(label)
+	if i := strings.Index(label, "="); i >= 0 {
+		f.LabelKey = strings.TrimSpace(label[:i])
+		f.LabelValue = strings.TrimSpace(label[i+1:])
+	} else {
+		f.LabelKey = label
+	}
+	return f
+}
+
+// SerializeContext to get the investigation context as text, one field per line and labels sorted by key
+func SerializeContext(c QueryContext) string {
+	lines := []string{
+		"description: " + c.Description,
+		"ticket_id: " + c.TicketID,
+		"ticket_url: " + c.TicketURL,
+	}
+	keys := make([]string, 0, len(c.Labels))
+	for k := range c.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		lines = append(lines, "label "+k+"="+c.Labels[k])
+	}
+	return strings.Join(lines, "\n")
+}
+
+// LabelList to get the labels of a query as key=value, sorted by key
+func (query DistributedQuery) LabelList() []string {
+	list := make([]string, 0, len(query.Labels))
+	for k, v := range query.Labels {
+		list = append(list, k+"="+v)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// GetLabels to get the labels of queries by name
+func (q *Queries) GetLabels(names ...string) (map[string]map[string]string, error) {
+	labels := make(map[string]map[string]string)
+	if len(names) == 0 {
+		return labels, nil
+	}
+	var entries []DistributedQueryLabel
+	if err := q.read().Where("name IN ?", names).Find(&entries).Error; err != nil {
+		return labels, err
+	}
+	for _, e := range entries {
+		if labels[e.Name] == nil {
+			labels[e.Name] = make(map[string]string)
+		}
+		labels[e.Name][e.Key] = e.Value
+	}
+	return labels, nil
+}
+
+// WithLabels to load the labels of queries
+func (q *Queries) WithLabels(qs []DistributedQuery) error {
+	names := make([]string, 0, len(qs))
+	for _, query := range qs {
+		names = append(names, query.Name)
+	}
+	labels, err := q.GetLabels(names...)
+	if err != nil {
+		return err
+	}
+	for i := range qs {
+		// Locked queries keep their context hidden
+		if !qs[i].Locked {
+			qs[i].Labels = labels[qs[i].Name]
+		}
+	}
+	return nil
+}
+
+// FilterContext to keep the queries matching a filter of label and ticket, with their labels loaded
+func (q *Queries) FilterContext(ctx context.Context, qs []DistributedQuery, f ContextFilter) ([]DistributedQuery, error) {
+	var labeled map[string]bool
+	if f.LabelKey != "" {
+		var names []string
+		db := q.read().WithContext(ctx).Model(&DistributedQueryLabel{}).Where("key = ?", f.LabelKey)
+		if f.LabelValue != "" {
+			db = db.Where("value = ?", f.LabelValue)
+		}
+		if err := db.Pluck("name", &names).Error; err != nil {
+			return qs, err
+		}
+		labeled = make(map[string]bool, len(names))
+		for _, n := range names {
+			labeled[n] = true
+		}
+	}
+	filtered := []DistributedQuery{}
+	for _, query := range qs {
+		// Locked queries can not be matched by a context that is hidden
+		if query.Locked && !f.Empty() {
+			continue
+		}
+		if labeled != nil && !labeled[query.Name] {
+			continue
+		}
+		if f.Ticket != "" && query.TicketID != f.Ticket && query.TicketURL != f.Ticket {
+			continue
+		}
+		filtered = append(filtered, query)
+	}
+	if err := q.WithLabels(filtered); err != nil {
+		return filtered, err
+	}
+	return filtered, nil
+}
+
+// GetWithLabels to get a query by name with its labels
+func (q *Queries) GetWithLabels(ctx context.Context, name string, envid uint) (DistributedQuery, error) {
+	query, err := q.GetCtx(ctx, name, envid)
+	if err != nil {
+		return query, err
+	}
+	labels, err := q.GetLabels(name)
+	if err != nil {
+		return query, err
+	}
+	query.Labels = labels[name]
+	return query, nil
+}
+
+// SetContext to replace the investigation context of a query, returning the context before the change
+func (q *Queries) SetContext(name string, envid uint, c QueryContext) (QueryContext, error) {
+	var before QueryContext
+	if err := ValidateContext(c); err != nil {
+		return before, err
+	}
+	query, err := q.GetWithLabels(context.Background(), name, envid)
+	if err != nil {
+		return before, err
+	}
+	if query.ID == 0 {
+		return before, gorm.ErrRecordNotFound
+	}
+	before = ContextOf(query)
+	err = q.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&query).Updates(map[string]interface{}{
+			"description": c.Description,
+			"ticket_id":   c.TicketID,
+			"ticket_url":  c.TicketURL,
+		}).Error; err != nil {
+			return fmt.Errorf("Updates %v", err)
+		}
+		if err := tx.Unscoped().Where("name = ?", name).Delete(&DistributedQueryLabel{}).Error; err != nil {
+			return fmt.Errorf("Delete %v", err)
+		}
+		for k, v := range c.Labels {
+			if err := tx.Create(&DistributedQueryLabel{Name: name, Key: k, Value: v}).Error; err != nil {
+				return fmt.Errorf("Create %v", err)
+			}
+		}
+		return nil
+	})
+	return before, err
+}
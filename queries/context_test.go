@@ -0,0 +1,61 @@
+package queries
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateContext(t *testing.T) {
+	c := QueryContext{
+		Description: "Hunting persistence in build hosts",
+		TicketID:    "IR-1234",
+		TicketURL:   "https://tickets.example.com/IR-1234",
+		Labels:      map[string]string{"team": "ir", "mitre.technique": "T1053"},
+	}
+	assert.NoError(t, ValidateContext(c))
+	assert.NoError(t, ValidateContext(QueryContext{}))
+	bad := c
+	bad.TicketURL = "javascript:alert(1)"
+	assert.Error(t, ValidateContext(bad))
+	bad = c
+	bad.Labels = map[string]string{"bad key": "value"}
+	assert.Error(t, ValidateContext(bad))
+	bad = c
+	bad.Labels = map[string]string{"team": strings.Repeat("a", MaxLabelValue+1)}
+	assert.EqualError(t, ValidateContext(bad), "value of label team is longer than 256 characters")
+	bad = c
+	bad.Labels = make(map[string]string)
+	for i := 0; i <= MaxQueryLabels; i++ {
+		bad.Labels[strings.Repeat("k", i+1)] = "v"
+	}
+	assert.EqualError(t, ValidateContext(bad), "queries can have up to 20 labels")
+}
+
+func TestParseLabels(t *testing.T) {
+	labels, err := ParseLabels([]string{"team=ir", " case = 42 ", "", "empty="})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"team": "ir", "case": "42", "empty": ""}, labels)
+	_, err = ParseLabels([]string{"team"})
+	assert.Error(t, err)
+	_, err = ParseLabels([]string{"=ir"})
+	assert.Error(t, err)
+}
+
+func TestParseContextFilter(t *testing.T) {
+	assert.Equal(t, ContextFilter{LabelKey: "team", LabelValue: "ir"}, ParseContextFilter("team=ir", ""))
+	assert.Equal(t, ContextFilter{LabelKey: "team", Ticket: "IR-1"}, ParseContextFilter(" team ", " IR-1 "))
+	assert.True(t, ParseContextFilter("", "").Empty())
+}
+
+func TestSerializeContext(t *testing.T) {
+	c := QueryContext{
+		Description: "why",
+		TicketID:    "IR-1",
+		Labels:      map[string]string{"team": "ir", "case": "42"},
+	}
+	assert.Equal(t, "description: why\nticket_id: IR-1\nticket_url: \nlabel case=42\nlabel team=ir", SerializeContext(c))
+	query := DistributedQuery{Labels: c.Labels}
+	assert.Equal(t, []string{"case=42", "team=ir"}, query.LabelList())
+}
@@ -0,0 +1,119 @@
+package queries
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// ExecutionNew for the first result of a node for a query
+	ExecutionNew string = "new"
+	// ExecutionRepeat for a result identical to the previous one of the node, sent again by osquery
+	ExecutionRepeat string = "repeat"
+	// ExecutionReplace for a result different to the previous one of the node, that replaces it
+	ExecutionReplace string = "replace"
+	// Number of locks to serialize the results of the same node for the same query
+	executionLocks = 64
+)
+
+// ResultHash to get the hash of a result of a node for a query, to detect results sent again.
+// The result is compacted so differences in whitespace do not count.
+func ResultHash(result json.RawMessage, status int, message string) string {
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, result); err != nil {
+		compact.Reset()
+		compact.Write(result)
+	}
+	h := sha256.New()
+	h.Write([]byte(strconv.Itoa(status) + "\x00" + message + "\x00"))
+	h.Write(compact.Bytes())
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ExecutionOutcome to decide what to do with a result of a node for a query, given its previous execution if any.
+// It returns the outcome and how the executions and errors of the query change, so each node counts once.
+func ExecutionOutcome(previous *DistributedQueryExecution, hash string, status int) (string, int, int) {
+	if previous == nil {
+		if status != 0 {
+			return ExecutionNew, 0, 1
+		}
+		return ExecutionNew, 1, 0
+	}
+	if previous.Hash == hash {
+		return ExecutionRepeat, 0, 0
+	}
+	// The latest result is the one that counts
+	executions, errors := 0, 0
+	if (previous.Result != 0) != (status != 0) {
+		if status != 0 {
+			executions, errors = -1, 1
+		} else {
+			executions, errors = 1, -1
+		}
+	}
+	return ExecutionReplace, executions, errors
+}
+
+// Helper to get the lock for the results of a node for a query
+func (q *Queries) executionLock(name, uuid string) func() {
+	h := fnv.New32a()
+	h.Write([]byte(name + "\x00" + uuid))
+	l := &q.executions[h.Sum32()%executionLocks]
+	l.Lock()
+	return l.Unlock
+}
+
+// RecordExecution to record the result of a node for a query, counting each node once. Results sent again
+// are a repeat and do not change anything, different results replace the previous one of the node.
+func (q *Queries) RecordExecution(name, uuid string, envid uint, status int, hash string) (string, error) {
+	unlock := q.executionLock(name, uuid)
+	defer unlock()
+	var outcome string
+	err := q.DB.Transaction(func(tx *gorm.DB) error {
+		var previous *DistributedQueryExecution
+		var existing []DistributedQueryExecution
+		if err := tx.Where("name = ? AND uuid = ?", name, uuid).Order("id desc").Limit(1).Find(&existing).Error; err != nil {
+			return fmt.Errorf("Find %v", err)
+		}
+		if len(existing) > 0 {
+			previous = &existing[0]
+		}
+		var executions, errors int
+		outcome, executions, errors = ExecutionOutcome(previous, hash, status)
+		switch outcome {
+		case ExecutionRepeat:
+			return nil
+		case ExecutionNew:
+			e := DistributedQueryExecution{Name: name, UUID: uuid, Result: status, Hash: hash}
+			if err := tx.Create(&e).Error; err != nil {
+				return fmt.Errorf("Create %v", err)
+			}
+		case ExecutionReplace:
+			if err := tx.Model(previous).Updates(map[string]interface{}{"result": status, "hash": hash}).Error; err != nil {
+				return fmt.Errorf("Updates %v", err)
+			}
+		}
+		if executions != 0 || errors != 0 {
+			err := tx.Model(&DistributedQuery{}).Where("name = ? AND environment_id = ?", name, envid).Updates(map[string]interface{}{
+				"executions": gorm.Expr("executions + ?", executions),
+				"errors":     gorm.Expr("errors + ?", errors),
+			}).Error
+			if err != nil {
+				return fmt.Errorf("Updates %v", err)
+			}
+		}
+		// The query is no longer pending for the node
+		if err := tx.Where("name = ? AND uuid = ?", name, uuid).Delete(&NodeQuery{}).Error; err != nil {
+			return fmt.Errorf("Delete %v", err)
+		}
+		return nil
+	})
+	return outcome, err
+}
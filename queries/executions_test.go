@@ -0,0 +1,61 @@
+package queries
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResultHash(t *testing.T) {
+	result := json.RawMessage(`[{"name":"sshd","pid":"1042"}]`)
+	assert.Equal(t, ResultHash(result, 0, ""), ResultHash(json.RawMessage(`[ {"name": "sshd", "pid": "1042"} ]`), 0, ""))
+	assert.NotEqual(t, ResultHash(result, 0, ""), ResultHash(json.RawMessage(`[{"name":"sshd","pid":"1043"}]`), 0, ""))
+	assert.NotEqual(t, ResultHash(result, 0, ""), ResultHash(result, 1, ""))
+	assert.NotEqual(t, ResultHash(result, 1, "timeout"), ResultHash(result, 1, "error"))
+}
+
+// Helper to apply results in order, like the DB does, returning the outcomes and the counters of the query
+func applyResults(hashes []string, statuses []int) ([]string, int, int) {
+	var previous *DistributedQueryExecution
+	var outcomes []string
+	executions, errors := 0, 0
+	for i, hash := range hashes {
+		outcome, e, errs := ExecutionOutcome(previous, hash, statuses[i])
+		outcomes = append(outcomes, outcome)
+		executions += e
+		errors += errs
+		if outcome != ExecutionRepeat {
+			previous = &DistributedQueryExecution{Hash: hash, Result: statuses[i]}
+		}
+	}
+	return outcomes, executions, errors
+}
+
+func TestExecutionOutcomeRetry(t *testing.T) {
+	// osquery sends the same result again when it misses the acknowledgment
+	hash := ResultHash(json.RawMessage(`[{"uptime":"42"}]`), 0, "")
+	outcomes, executions, errors := applyResults([]string{hash, hash, hash}, []int{0, 0, 0})
+	assert.Equal(t, []string{ExecutionNew, ExecutionRepeat, ExecutionRepeat}, outcomes)
+	assert.Equal(t, 1, executions)
+	assert.Equal(t, 0, errors)
+}
+
+func TestExecutionOutcomeReplace(t *testing.T) {
+	first := ResultHash(json.RawMessage(`[{"uptime":"42"}]`), 0, "")
+	second := ResultHash(json.RawMessage(`[{"uptime":"43"}]`), 0, "")
+	outcomes, executions, errors := applyResults([]string{first, second, second}, []int{0, 0, 0})
+	assert.Equal(t, []string{ExecutionNew, ExecutionReplace, ExecutionRepeat}, outcomes)
+	assert.Equal(t, 1, executions)
+	assert.Equal(t, 0, errors)
+	// An error followed by a successful result counts only the latest
+	failed := ResultHash(nil, 1, "timeout")
+	outcomes, executions, errors = applyResults([]string{failed, first}, []int{1, 0})
+	assert.Equal(t, []string{ExecutionNew, ExecutionReplace}, outcomes)
+	assert.Equal(t, 1, executions)
+	assert.Equal(t, 0, errors)
+	outcomes, executions, errors = applyResults([]string{first, failed}, []int{0, 1})
+	assert.Equal(t, []string{ExecutionNew, ExecutionReplace}, outcomes)
+	assert.Equal(t, 0, executions)
+	assert.Equal(t, 1, errors)
+}
@@ -0,0 +1,154 @@
+package queries
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultExpirationHours is how long queries are distributed when the environment has no default
+	DefaultExpirationHours int64 = 24
+	// DefaultExpirationMinHours is the shortest expiration that can be requested when none is configured
+	DefaultExpirationMinHours int64 = 1
+	// DefaultExpirationMaxHours is the longest expiration that can be requested when none is configured
+	DefaultExpirationMaxHours int64 = 720
+	// DefaultExpirationInterval is how often expired queries are completed
+	DefaultExpirationInterval = time.Minute
+)
+
+// ExpirationRange to keep the default expiration in hours of the queries of an environment and the range allowed to
+// override it at creation
+type ExpirationRange struct {
+	Default int64 `json:"default_hours"`
+	Min     int64 `json:"min_hours"`
+	Max     int64 `json:"max_hours"`
+}
+
+// NewExpirationRange to prepare the expiration range with defaults for missing values, the default is kept in range
+func NewExpirationRange(def, min, max int64) ExpirationRange {
+	if min <= 0 {
+		min = DefaultExpirationMinHours
+	}
+	if max <= 0 {
+		max = DefaultExpirationMaxHours
+	}
+	if max < min {
+		max = min
+	}
+	if def <= 0 {
+		def = DefaultExpirationHours
+	}
+	if def < min {
+		def = min
+	}
+	if def > max {
+		def = max
+	}
+	return ExpirationRange{Default: def, Min: min, Max: max}
+}
+
+// Hours to get the expiration in hours of a new query, the default when none is requested
+func (r ExpirationRange) Hours(requested int64) (int64, error) {
+	if requested == 0 {
+		return r.Default, nil
+	}
+	if requested < r.Min || requested > r.Max {
+		return 0, fmt.Errorf("expiration must be between %dh and %dh", r.Min, r.Max)
+	}
+	return requested, nil
+}
+
+// ExpiresAt to get when a new query expires from the requested expiration, like 48h or 2d, empty for the default
+func (r ExpirationRange) ExpiresAt(requested string, now time.Time) (time.Time, error) {
+	hours, err := ParseExpiration(requested)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if hours, err = r.Hours(hours); err != nil {
+		return time.Time{}, err
+	}
+	return now.Add(time.Duration(hours) * time.Hour), nil
+}
+
+// ParseExpiration to parse an expiration in whole hours, as hours like 36 or 36h or days like 3d. Empty is 0.
+func ParseExpiration(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	if hours, err := strconv.ParseInt(value, 10, 64); err == nil && hours > 0 {
+		return hours, nil
+	}
+	if strings.HasSuffix(value, "d") {
+		if days, err := strconv.ParseInt(strings.TrimSuffix(value, "d"), 10, 64); err == nil && days > 0 {
+			return days * 24, nil
+		}
+	} else if d, err := time.ParseDuration(value); err == nil && d > 0 && d%time.Hour == 0 {
+		return int64(d / time.Hour), nil
+	}
+	return 0, fmt.Errorf("invalid expiration %s, use whole hours like 24h or days like 3d", value)
+}
+
+// Expired to check if a query stopped being distributed because it expired before all nodes answered.
+// Queries created without expiration never expire.
+func (q DistributedQuery) Expired(now time.Time) bool {
+	return !q.ExpiresAt.IsZero() && !now.Before(q.ExpiresAt)
+}
+
+// ExpireQueries to complete the active queries that expired, they are no longer distributed to nodes.
+// It returns the number of queries that expired.
+func (q *Queries) ExpireQueries(now time.Time) (int, error) {
+	var names []string
+	if err := q.DB.Model(&DistributedQuery{}).Where(
+		"active = ? AND completed = ? AND deleted = ? AND expires_at > ? AND expires_at <= ?",
+		true, false, false, time.Time{}, now,
+	).Pluck("name", &names).Error; err != nil {
+		return 0, fmt.Errorf("Pluck %v", err)
+	}
+	for _, name := range names {
+		tx := q.DB.Model(&DistributedQuery{}).Where("name = ? AND completed = ?", name, false).Updates(map[string]interface{}{"completed": true, "active": false})
+		if tx.Error != nil {
+			return 0, fmt.Errorf("Updates %v", tx.Error)
+		}
+		if err := q.ClearPending(name); err != nil {
+			return 0, err
+		}
+		// Queries completed meanwhile were already notified
+		if tx.RowsAffected == 1 && q.OnComplete != nil {
+			var query DistributedQuery
+			if err := q.DB.Where("name = ?", name).First(&query).Error; err == nil {
+				q.OnComplete(query)
+			}
+		}
+	}
+	return len(names), nil
+}
+
+// StartExpiration to complete expired queries periodically, until ctx is cancelled
+func (q *Queries) StartExpiration(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultExpirationInterval
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				expired, err := q.ExpireQueries(time.Now())
+				if err != nil {
+					log.Printf("error expiring queries %v", err)
+				}
+				if expired > 0 {
+					log.Printf("Expired %d queries", expired)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,61 @@
+package queries
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewExpirationRange(t *testing.T) {
+	assert.Equal(t, ExpirationRange{Default: 24, Min: 1, Max: 720}, NewExpirationRange(0, 0, 0))
+	assert.Equal(t, ExpirationRange{Default: 48, Min: 2, Max: 72}, NewExpirationRange(48, 2, 72))
+	assert.Equal(t, ExpirationRange{Default: 12, Min: 1, Max: 12}, NewExpirationRange(48, 0, 12))
+	assert.Equal(t, ExpirationRange{Default: 36, Min: 36, Max: 36}, NewExpirationRange(0, 36, 12))
+}
+
+func TestExpirationRangeHours(t *testing.T) {
+	r := NewExpirationRange(24, 2, 72)
+	hours, err := r.Hours(0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(24), hours)
+	hours, err = r.Hours(48)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(48), hours)
+	_, err = r.Hours(1)
+	assert.Error(t, err)
+	_, err = r.Hours(96)
+	assert.Error(t, err)
+}
+
+func TestExpiresAt(t *testing.T) {
+	now := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+	r := NewExpirationRange(24, 1, 72)
+	expires, err := r.ExpiresAt("", now)
+	assert.NoError(t, err)
+	assert.Equal(t, now.Add(24*time.Hour), expires)
+	expires, err = r.ExpiresAt("2d", now)
+	assert.NoError(t, err)
+	assert.Equal(t, now.Add(48*time.Hour), expires)
+	_, err = r.ExpiresAt("4d", now)
+	assert.Error(t, err)
+}
+
+func TestParseExpiration(t *testing.T) {
+	for value, expected := range map[string]int64{"": 0, "36": 36, "36h": 36, "3d": 72, " 12h ": 12} {
+		hours, err := ParseExpiration(value)
+		assert.NoError(t, err, value)
+		assert.Equal(t, expected, hours, value)
+	}
+	for _, value := range []string{"0", "-1", "90m", "1.5d", "abc", "0d"} {
+		_, err := ParseExpiration(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestExpired(t *testing.T) {
+	now := time.Now()
+	assert.False(t, DistributedQuery{}.Expired(now))
+	assert.False(t, DistributedQuery{ExpiresAt: now.Add(time.Minute)}.Expired(now))
+	assert.True(t, DistributedQuery{ExpiresAt: now}.Expired(now))
+}
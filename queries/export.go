@@ -0,0 +1,161 @@
+package queries
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	// ExportCSV to export results as CSV, values as sent by osquery
+	ExportCSV string = "csv"
+	// ExportJSON to export results as a JSON array of rows with typed values
+	ExportJSON string = "json"
+	// ExportParquet to export results as a Parquet file with typed columns
+	ExportParquet string = "parquet"
+	// ExportNodeColumn is the column with the UUID of the node in exported results
+	ExportNodeColumn string = "node_uuid"
+	// ExportTimeColumn is the column with the time results were collected in exported results
+	ExportTimeColumn string = "collected_at"
+)
+
+// ExportFormats to list the formats to export results of queries
+var ExportFormats = []string{ExportCSV, ExportJSON, ExportParquet}
+
+// ValidExportFormat to check if a format to export results is supported
+func ValidExportFormat(format string) bool {
+	for _, f := range ExportFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// ExportContentType to get the content type of results exported in a format
+func ExportContentType(format string) string {
+	switch format {
+	case ExportJSON:
+		return "application/json"
+	case ExportParquet:
+		return "application/vnd.apache.parquet"
+	}
+	return "text/csv"
+}
+
+// ResultExporter to write the rows of results of a query in one format
+type ResultExporter interface {
+	Write(node string, collected time.Time, row map[string]string) error
+	Close() error
+}
+
+// Column of exported results with the column of the results it comes from
+type exportColumn struct {
+	ResultColumn
+	Source string
+}
+
+// Helper to get the columns of the schema for exported results, columns with the same name as
+// the node or the collection time are renamed so they are not lost
+func exportColumns(schema ResultSchema) []exportColumn {
+	columns := []exportColumn{}
+	for _, c := range schema.Columns {
+		e := exportColumn{ResultColumn: c, Source: c.Name}
+		if c.Name == ExportNodeColumn || c.Name == ExportTimeColumn {
+			e.Name = "result_" + c.Name
+		}
+		columns = append(columns, e)
+	}
+	return columns
+}
+
+// NewResultExporter to export the rows of results with the inferred schema of the query
+func NewResultExporter(w io.Writer, format string, schema ResultSchema) (ResultExporter, error) {
+	switch format {
+	case ExportCSV:
+		return newCSVExporter(w, schema)
+	case ExportJSON:
+		return newJSONExporter(w, schema)
+	case ExportParquet:
+		return NewParquetWriter(w, schema, ParquetRowGroup)
+	}
+	return nil, fmt.Errorf("invalid export format %s", format)
+}
+
+// Exporter of results as CSV with a header
+type csvExporter struct {
+	w       *csv.Writer
+	columns []exportColumn
+}
+
+func newCSVExporter(w io.Writer, schema ResultSchema) (*csvExporter, error) {
+	e := &csvExporter{w: csv.NewWriter(w), columns: exportColumns(schema)}
+	header := []string{ExportNodeColumn, ExportTimeColumn}
+	for _, c := range e.columns {
+		header = append(header, c.Name)
+	}
+	if err := e.w.Write(header); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *csvExporter) Write(node string, collected time.Time, row map[string]string) error {
+	record := []string{node, collected.UTC().Format(time.RFC3339)}
+	for _, c := range e.columns {
+		record = append(record, row[c.Source])
+	}
+	return e.w.Write(record)
+}
+
+func (e *csvExporter) Close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// Exporter of results as a JSON array, one row at a time
+type jsonExporter struct {
+	w       io.Writer
+	columns []exportColumn
+	rows    int
+}
+
+func newJSONExporter(w io.Writer, schema ResultSchema) (*jsonExporter, error) {
+	if _, err := io.WriteString(w, "["); err != nil {
+		return nil, err
+	}
+	return &jsonExporter{w: w, columns: exportColumns(schema)}, nil
+}
+
+func (e *jsonExporter) Write(node string, collected time.Time, row map[string]string) error {
+	typed := map[string]interface{}{
+		ExportNodeColumn: node,
+		ExportTimeColumn: collected.UTC(),
+	}
+	for _, c := range e.columns {
+		var v interface{}
+		if value, ok := row[c.Source]; ok {
+			v = TypedValue(c.Type, value)
+		}
+		typed[c.Name] = v
+	}
+	data, err := json.Marshal(typed)
+	if err != nil {
+		return err
+	}
+	if e.rows > 0 {
+		if _, err := io.WriteString(e.w, ","); err != nil {
+			return err
+		}
+	}
+	e.rows++
+	_, err = e.w.Write(data)
+	return err
+}
+
+func (e *jsonExporter) Close() error {
+	_, err := io.WriteString(e.w, "]\n")
+	return err
+}
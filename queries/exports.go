@@ -0,0 +1,359 @@
+package queries
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// ExportJobPending for export jobs waiting to be materialized
+	ExportJobPending string = "pending"
+	// ExportJobRunning for export jobs being materialized
+	ExportJobRunning string = "running"
+	// ExportJobReady for export jobs with the artifact ready to download
+	ExportJobReady string = "ready"
+	// ExportJobFailed for export jobs that could not be materialized
+	ExportJobFailed string = "failed"
+	// ExportJobCanceled for export jobs canceled by users
+	ExportJobCanceled string = "canceled"
+	// ExportJobExpired for export jobs with the artifact removed after the TTL
+	ExportJobExpired string = "expired"
+	// ExportStorageLocal to keep export artifacts in a local directory
+	ExportStorageLocal string = "local"
+	// ExportStorageS3 to keep export artifacts in a S3 bucket
+	ExportStorageS3 string = "s3"
+	// DefaultExportThreshold is the number of rows of results from which exports are materialized
+	DefaultExportThreshold int = 100000
+	// DefaultExportTTL is the time export artifacts are kept
+	DefaultExportTTL = 24 * time.Hour
+	// DefaultExportJobsPerUser is the number of export jobs that one user can have materializing at once
+	DefaultExportJobsPerUser int = 3
+	// exportCancelRows is the number of rows written between checks for cancellation
+	exportCancelRows int = 10000
+)
+
+// ErrExportCanceled when an export job is canceled while it is materialized
+var ErrExportCanceled = errors.New("export job canceled")
+
+// ErrExportLimit when a user has too many export jobs materializing
+var ErrExportLimit = errors.New("too many export jobs materializing, wait for them to finish or cancel them")
+
+// ExportJob to materialize the results of a query as an artifact that can be downloaded in ranges
+type ExportJob struct {
+	gorm.Model
+	JobID         string `gorm:"not null;unique;index"`
+	EnvironmentID uint   `gorm:"index"`
+	Environment   string
+	Query         string `gorm:"index"`
+	Format        string
+	Status        string
+	Error         string
+	Storage       string
+	Location      string `json:"-"`
+	Rows          int
+	Size          int64
+	SHA256        string
+	Creator       string `gorm:"index"`
+	CanceledBy    string
+	ExpiresAt     time.Time
+}
+
+// ExportArtifact to hold the size and the hash of a materialized export
+type ExportArtifact struct {
+	Rows   int
+	Size   int64
+	SHA256 string
+}
+
+// ExportStore to keep the artifacts of export jobs, they are read with seeks to serve ranges
+type ExportStore interface {
+	// Name of the storage, recorded in the export jobs
+	Name() string
+	// Dir to materialize the artifacts before they are saved
+	Dir() string
+	// Save the materialized artifact in a local file, returning its location
+	Save(ctx context.Context, key string, file string) (string, error)
+	// Open an artifact to read it, with its size
+	Open(ctx context.Context, location string, size int64) (io.ReadSeekCloser, error)
+	// Remove an artifact
+	Remove(ctx context.Context, location string) error
+}
+
+// Active to check if an export job is still materializing
+func (j ExportJob) Active() bool {
+	return j.Status == ExportJobPending || j.Status == ExportJobRunning
+}
+
+// Downloadable to check if the artifact of an export job can be downloaded
+func (j ExportJob) Downloadable(now time.Time) bool {
+	return j.Status == ExportJobReady && j.Location != "" && now.Before(j.ExpiresAt)
+}
+
+// Filename to get the name of the file of an export job when it is downloaded
+func (j ExportJob) Filename() string {
+	return fmt.Sprintf("%s-%s.%s", j.Query, j.Environment, j.Format)
+}
+
+// MaterializeExport to write the results of a query in one format, keeping the size and the hash of what was written.
+// The function canceled is called every few rows and stops the export when it returns true.
+func MaterializeExport(w io.Writer, format string, schema ResultSchema, rows func(fn func(node string, collected time.Time, row map[string]string) error) error, canceled func() bool) (ExportArtifact, error) {
+	hash := sha256.New()
+	counter := &countWriter{}
+	exporter, err := NewResultExporter(io.MultiWriter(w, hash, counter), format, schema)
+	if err != nil {
+		return ExportArtifact{}, err
+	}
+	written := 0
+	if err := rows(func(node string, collected time.Time, row map[string]string) error {
+		written++
+		if canceled != nil && written%exportCancelRows == 0 && canceled() {
+			return ErrExportCanceled
+		}
+		return exporter.Write(node, collected, row)
+	}); err != nil {
+		return ExportArtifact{}, err
+	}
+	if err := exporter.Close(); err != nil {
+		return ExportArtifact{}, err
+	}
+	return ExportArtifact{Rows: written, Size: counter.n, SHA256: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+// LocalExportStore to keep export artifacts in a local directory
+type LocalExportStore struct {
+	Directory string
+}
+
+// CreateLocalExportStore to keep export artifacts in a directory, it is created if it does not exist
+func CreateLocalExportStore(dir string) (*LocalExportStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("error creating %s - %v", dir, err)
+	}
+	return &LocalExportStore{Directory: dir}, nil
+}
+
+// Name of the local storage
+func (s *LocalExportStore) Name() string {
+	return ExportStorageLocal
+}
+
+// Dir to materialize artifacts in the same directory, so saving them is a rename
+func (s *LocalExportStore) Dir() string {
+	return s.Directory
+}
+
+// Save to move a materialized artifact to its final name
+func (s *LocalExportStore) Save(ctx context.Context, key string, file string) (string, error) {
+	location := filepath.Join(s.Directory, filepath.Base(key))
+	if err := os.Rename(file, location); err != nil {
+		return "", err
+	}
+	return location, nil
+}
+
+// Open to read an artifact from the directory
+func (s *LocalExportStore) Open(ctx context.Context, location string, size int64) (io.ReadSeekCloser, error) {
+	return os.Open(filepath.Join(s.Directory, filepath.Base(location)))
+}
+
+// Remove to delete an artifact from the directory, it is not an error if it is already gone
+func (s *LocalExportStore) Remove(ctx context.Context, location string) error {
+	if err := os.Remove(filepath.Join(s.Directory, filepath.Base(location))); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// NewExportJob to record an export job pending to be materialized, it expires after the TTL if it does not finish.
+// Users can only have a few export jobs materializing at once.
+func (q *Queries) NewExportJob(job ExportJob, perUser int, ttl time.Duration) (ExportJob, error) {
+	if !ValidExportFormat(job.Format) {
+		return job, fmt.Errorf("invalid export format %s", job.Format)
+	}
+	if perUser > 0 {
+		var active int64
+		if err := q.DB.Model(&ExportJob{}).Where("creator = ? AND status IN ?", job.Creator, []string{ExportJobPending, ExportJobRunning}).Count(&active).Error; err != nil {
+			return job, fmt.Errorf("Count %v", err)
+		}
+		if int(active) >= perUser {
+			return job, ErrExportLimit
+		}
+	}
+	job.JobID = GenExportJobID()
+	job.Status = ExportJobPending
+	job.ExpiresAt = time.Now().Add(ttl)
+	if err := q.DB.Create(&job).Error; err != nil {
+		return job, fmt.Errorf("Create %v", err)
+	}
+	return job, nil
+}
+
+// FindExportJob to get the export job of a user for the results of a query in one format that is materializing or ready
+func (q *Queries) FindExportJob(name, format, creator string, envid uint) (ExportJob, bool, error) {
+	var job ExportJob
+	err := q.DB.Where("query = ? AND format = ? AND creator = ? AND environment_id = ? AND status IN ? AND expires_at > ?",
+		name, format, creator, envid, []string{ExportJobPending, ExportJobRunning, ExportJobReady}, time.Now()).Order("created_at DESC").First(&job).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return job, false, nil
+	}
+	if err != nil {
+		return job, false, err
+	}
+	return job, true, nil
+}
+
+// GetExportJob to get an export job of an environment by ID
+func (q *Queries) GetExportJob(jobID string, envid uint) (ExportJob, error) {
+	var job ExportJob
+	if err := q.DB.Where("job_id = ? AND environment_id = ?", jobID, envid).First(&job).Error; err != nil {
+		return job, err
+	}
+	return job, nil
+}
+
+// ExportJobs to get the export jobs of an environment, newest first
+func (q *Queries) ExportJobs(envid uint) ([]ExportJob, error) {
+	var jobs []ExportJob
+	if err := q.read().Where("environment_id = ?", envid).Order("created_at DESC").Find(&jobs).Error; err != nil {
+		return jobs, err
+	}
+	return jobs, nil
+}
+
+// QueryExportJobs to get the export jobs of the results of a query, newest first
+func (q *Queries) QueryExportJobs(name string, envid uint) ([]ExportJob, error) {
+	var jobs []ExportJob
+	if err := q.read().Where("query = ? AND environment_id = ?", name, envid).Order("created_at DESC").Find(&jobs).Error; err != nil {
+		return jobs, err
+	}
+	return jobs, nil
+}
+
+// CancelExportJob to cancel an export job, jobs materializing stop and artifacts are removed by the cleanup
+func (q *Queries) CancelExportJob(jobID string, envid uint, username string) error {
+	job, err := q.GetExportJob(jobID, envid)
+	if err != nil {
+		return err
+	}
+	if !job.Active() && job.Status != ExportJobReady {
+		return fmt.Errorf("export job %s is %s", jobID, job.Status)
+	}
+	if err := q.DB.Model(&job).Updates(map[string]interface{}{"status": ExportJobCanceled, "canceled_by": username}).Error; err != nil {
+		return fmt.Errorf("Updates %v", err)
+	}
+	return nil
+}
+
+// Helper to check if an export job was canceled while it is materialized
+func (q *Queries) exportCanceled(id uint) bool {
+	var job ExportJob
+	if err := q.DB.Select("status").Where("id = ?", id).First(&job).Error; err != nil {
+		return false
+	}
+	return job.Status == ExportJobCanceled
+}
+
+// RunExportJob to materialize a pending export job with the results of its query and save the artifact, which expires after the TTL.
+// The job is marked as failed on errors and the artifact is discarded if it is canceled.
+func (q *Queries) RunExportJob(ctx context.Context, job ExportJob, schema ResultSchema, rows func(fn func(node string, collected time.Time, row map[string]string) error) error, store ExportStore, ttl time.Duration) (ExportJob, error) {
+	fail := func(err error) (ExportJob, error) {
+		if errors.Is(err, ErrExportCanceled) {
+			return job, err
+		}
+		if uErr := q.DB.WithContext(ctx).Model(&job).Updates(map[string]interface{}{"status": ExportJobFailed, "error": err.Error()}).Error; uErr != nil {
+			return job, fmt.Errorf("Updates %v", uErr)
+		}
+		return job, err
+	}
+	res := q.DB.WithContext(ctx).Model(&ExportJob{}).Where("id = ? AND status = ?", job.ID, ExportJobPending).Update("status", ExportJobRunning)
+	if res.Error != nil {
+		return job, fmt.Errorf("Update %v", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return job, ErrExportCanceled
+	}
+	f, err := os.CreateTemp(store.Dir(), job.JobID+"-*.tmp")
+	if err != nil {
+		return fail(err)
+	}
+	defer func() {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}()
+	artifact, err := MaterializeExport(f, job.Format, schema, rows, func() bool {
+		return q.exportCanceled(job.ID)
+	})
+	if err != nil {
+		return fail(err)
+	}
+	if err := f.Close(); err != nil {
+		return fail(err)
+	}
+	if q.exportCanceled(job.ID) {
+		return job, ErrExportCanceled
+	}
+	location, err := store.Save(ctx, job.JobID+"."+job.Format, f.Name())
+	if err != nil {
+		return fail(err)
+	}
+	// Only jobs still running become ready, so a cancellation right before saving is not lost
+	res = q.DB.WithContext(ctx).Model(&ExportJob{}).Where("id = ? AND status = ?", job.ID, ExportJobRunning).Updates(map[string]interface{}{
+		"status":     ExportJobReady,
+		"storage":    store.Name(),
+		"location":   location,
+		"rows":       artifact.Rows,
+		"size":       artifact.Size,
+		"sha256":     artifact.SHA256,
+		"expires_at": time.Now().Add(ttl),
+	})
+	if res.Error != nil {
+		return job, fmt.Errorf("Updates %v", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		if err := store.Remove(ctx, location); err != nil {
+			return job, err
+		}
+		return job, ErrExportCanceled
+	}
+	return q.GetExportJob(job.JobID, job.EnvironmentID)
+}
+
+// CleanupExportJobs to remove the artifacts of export jobs that expired or were canceled, returning how many were removed.
+// Jobs that did not finish before expiring are marked as failed.
+func (q *Queries) CleanupExportJobs(ctx context.Context, store ExportStore, now time.Time) (int, error) {
+	var jobs []ExportJob
+	if err := q.DB.WithContext(ctx).Where("(location <> '' AND (status = ? OR expires_at <= ?)) OR (status IN ? AND expires_at <= ?)",
+		ExportJobCanceled, now, []string{ExportJobPending, ExportJobRunning}, now).Find(&jobs).Error; err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, job := range jobs {
+		updates := map[string]interface{}{"location": ""}
+		switch {
+		case job.Active():
+			updates["status"] = ExportJobFailed
+			updates["error"] = "export job expired before it was materialized"
+		case job.Status == ExportJobReady:
+			updates["status"] = ExportJobExpired
+		}
+		if job.Location != "" {
+			if err := store.Remove(ctx, job.Location); err != nil {
+				return removed, fmt.Errorf("error removing artifact of %s - %v", job.JobID, err)
+			}
+			removed++
+		}
+		if err := q.DB.WithContext(ctx).Model(&job).Updates(updates).Error; err != nil {
+			return removed, fmt.Errorf("Updates %v", err)
+		}
+	}
+	return removed, nil
+}
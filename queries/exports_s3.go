@@ -0,0 +1,140 @@
+package queries
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// S3ExportStore to keep export artifacts in a S3 bucket, they are materialized in a local directory before uploading them
+type S3ExportStore struct {
+	Client    *s3.Client
+	Uploader  *manager.Uploader
+	Bucket    string
+	Prefix    string
+	Directory string
+}
+
+// CreateS3ExportStore to keep export artifacts in a bucket under a prefix, reusing an existing client
+func CreateS3ExportStore(client *s3.Client, bucket, prefix, dir string) (*S3ExportStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("error creating %s - %v", dir, err)
+	}
+	return &S3ExportStore{
+		Client:    client,
+		Uploader:  manager.NewUploader(client),
+		Bucket:    bucket,
+		Prefix:    prefix,
+		Directory: dir,
+	}, nil
+}
+
+// Name of the S3 storage
+func (s *S3ExportStore) Name() string {
+	return ExportStorageS3
+}
+
+// Dir to materialize artifacts before uploading them
+func (s *S3ExportStore) Dir() string {
+	return s.Directory
+}
+
+// Save to upload a materialized artifact in parts, the local file is removed by the caller
+func (s *S3ExportStore) Save(ctx context.Context, key string, file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	location := path.Join(s.Prefix, key)
+	if _, err := s.Uploader.Upload(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(location),
+		Body:   f,
+	}); err != nil {
+		return "", fmt.Errorf("error sending export to s3 - %s", err)
+	}
+	return location, nil
+}
+
+// Open to read an artifact from the bucket, each read after a seek requests the range from there
+func (s *S3ExportStore) Open(ctx context.Context, location string, size int64) (io.ReadSeekCloser, error) {
+	return &s3RangeReader{ctx: ctx, store: s, key: location, size: size}, nil
+}
+
+// Remove to delete an artifact from the bucket
+func (s *S3ExportStore) Remove(ctx context.Context, location string) error {
+	if _, err := s.Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(location),
+	}); err != nil {
+		return fmt.Errorf("DeleteObject - %s", err)
+	}
+	return nil
+}
+
+// s3RangeReader to read an object of S3 with seeks, so ranges of artifacts are served without downloading them
+type s3RangeReader struct {
+	ctx    context.Context
+	store  *S3ExportStore
+	key    string
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
+
+func (r *s3RangeReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		out, err := r.store.Client.GetObject(r.ctx, &s3.GetObjectInput{
+			Bucket: aws.String(r.store.Bucket),
+			Key:    aws.String(r.key),
+			Range:  aws.String(fmt.Sprintf("bytes=%d-", r.offset)),
+		})
+		if err != nil {
+			return 0, fmt.Errorf("GetObject - %s", err)
+		}
+		r.body = out.Body
+	}
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+func (r *s3RangeReader) Seek(offset int64, whence int) (int64, error) {
+	var next int64
+	switch whence {
+	case io.SeekStart:
+		next = offset
+	case io.SeekCurrent:
+		next = r.offset + offset
+	case io.SeekEnd:
+		next = r.size + offset
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if next < 0 {
+		return 0, fmt.Errorf("negative position %d", next)
+	}
+	if next != r.offset && r.body != nil {
+		_ = r.body.Close()
+		r.body = nil
+	}
+	r.offset = next
+	return next, nil
+}
+
+func (r *s3RangeReader) Close() error {
+	if r.body != nil {
+		return r.body.Close()
+	}
+	return nil
+}
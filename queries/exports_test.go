@@ -0,0 +1,95 @@
+package queries
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper to prepare the rows of results for export tests
+func exportRows(count int) func(fn func(node string, collected time.Time, row map[string]string) error) error {
+	collected := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	return func(fn func(node string, collected time.Time, row map[string]string) error) error {
+		for i := 0; i < count; i++ {
+			if err := fn("AAAA-1111", collected, map[string]string{"pid": "1", "name": "launchd"}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func TestMaterializeExport(t *testing.T) {
+	schema := ResultSchema{Rows: 3, Columns: []ResultColumn{{Name: "name", Type: "TEXT"}, {Name: "pid", Type: "INTEGER"}}}
+	t.Run("size and hash of what was written", func(t *testing.T) {
+		var buf bytes.Buffer
+		artifact, err := MaterializeExport(&buf, ExportCSV, schema, exportRows(3), nil)
+		assert.NoError(t, err)
+		sum := sha256.Sum256(buf.Bytes())
+		assert.Equal(t, 3, artifact.Rows)
+		assert.Equal(t, int64(buf.Len()), artifact.Size)
+		assert.Equal(t, hex.EncodeToString(sum[:]), artifact.SHA256)
+		assert.Contains(t, buf.String(), "launchd")
+	})
+	t.Run("canceled", func(t *testing.T) {
+		checks := 0
+		_, err := MaterializeExport(io.Discard, ExportJSON, schema, exportRows(exportCancelRows*2), func() bool {
+			checks++
+			return true
+		})
+		assert.ErrorIs(t, err, ErrExportCanceled)
+		assert.Equal(t, 1, checks)
+	})
+	t.Run("invalid format", func(t *testing.T) {
+		_, err := MaterializeExport(io.Discard, "xml", schema, exportRows(1), nil)
+		assert.Error(t, err)
+	})
+}
+
+func TestLocalExportStore(t *testing.T) {
+	ctx := context.Background()
+	store, err := CreateLocalExportStore(filepath.Join(t.TempDir(), "exports"))
+	assert.NoError(t, err)
+	assert.Equal(t, ExportStorageLocal, store.Name())
+	f, err := os.CreateTemp(store.Dir(), "export-*.tmp")
+	assert.NoError(t, err)
+	_, err = f.WriteString("0123456789")
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+	location, err := store.Save(ctx, "export_abc.csv", f.Name())
+	assert.NoError(t, err)
+	_, err = os.Stat(f.Name())
+	assert.True(t, os.IsNotExist(err))
+	r, err := store.Open(ctx, location, 10)
+	assert.NoError(t, err)
+	_, err = r.Seek(4, io.SeekStart)
+	assert.NoError(t, err)
+	rest, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "456789", string(rest))
+	assert.NoError(t, r.Close())
+	assert.NoError(t, store.Remove(ctx, location))
+	assert.NoError(t, store.Remove(ctx, location))
+	_, err = store.Open(ctx, location, 10)
+	assert.Error(t, err)
+}
+
+func TestExportJobState(t *testing.T) {
+	now := time.Now()
+	job := ExportJob{Query: "query_1", Environment: "dev", Format: ExportParquet, Status: ExportJobReady, Location: "export_1.parquet", ExpiresAt: now.Add(time.Hour)}
+	assert.True(t, job.Downloadable(now))
+	assert.False(t, job.Downloadable(now.Add(2*time.Hour)))
+	assert.False(t, job.Active())
+	assert.Equal(t, "query_1-dev.parquet", job.Filename())
+	job.Status = ExportJobRunning
+	assert.True(t, job.Active())
+	assert.False(t, job.Downloadable(now))
+}
@@ -0,0 +1,266 @@
+package queries
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// MaxGroupName is the maximum length of the name of a query group
+	MaxGroupName = 64
+	// GroupInflightTimeout is how long a query handed to a node counts as in flight for its group,
+	// so nodes that never answer free their slot
+	GroupInflightTimeout = 10 * time.Minute
+)
+
+// Group names use letters, digits and _ . -
+var groupNameRe = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+// QueryGroup to keep the concurrency limits of a group of queries in an environment, zero for no limit
+type QueryGroup struct {
+	gorm.Model
+	Name          string `gorm:"uniqueIndex:idx_query_groups_env_name"`
+	EnvironmentID uint   `gorm:"uniqueIndex:idx_query_groups_env_name"`
+	MaxPerNode    int
+	MaxTotal      int
+	UpdatedBy     string
+}
+
+// GroupUtilization to show the active queries of a group and how many of them are in flight
+type GroupUtilization struct {
+	Name       string   `json:"name"`
+	MaxPerNode int      `json:"max_per_node"`
+	MaxTotal   int      `json:"max_total"`
+	Queries    []string `json:"queries"`
+	InFlight   int64    `json:"in_flight"`
+	Waiting    int64    `json:"waiting"`
+}
+
+// Count of pending entries by group
+type groupCount struct {
+	QueryGroup string
+	Total      int64
+}
+
+// ValidateGroup to check the name of a query group, empty for ungrouped queries
+func ValidateGroup(name string) error {
+	if name == "" {
+		return nil
+	}
+	if len(name) > MaxGroupName || !groupNameRe.MatchString(name) {
+		return fmt.Errorf("invalid group %q, use up to %d letters, digits and _ . -", name, MaxGroupName)
+	}
+	return nil
+}
+
+// SetGroupLimits to set the concurrency limits of a group in an environment, they are used by the next
+// distribution decisions
+func (q *Queries) SetGroupLimits(envid uint, name string, perNode, total int, user string) (QueryGroup, error) {
+	var group QueryGroup
+	if name == "" {
+		return group, fmt.Errorf("group can not be empty")
+	}
+	if err := ValidateGroup(name); err != nil {
+		return group, err
+	}
+	if perNode < 0 || total < 0 {
+		return group, fmt.Errorf("limits can not be negative")
+	}
+	if err := q.DB.Where("environment_id = ? AND name = ?", envid, name).Find(&group).Error; err != nil {
+		return group, fmt.Errorf("Find %v", err)
+	}
+	if group.ID == 0 {
+		group = QueryGroup{Name: name, EnvironmentID: envid, MaxPerNode: perNode, MaxTotal: total, UpdatedBy: user}
+		if err := q.DB.Create(&group).Error; err != nil {
+			return group, fmt.Errorf("Create %v", err)
+		}
+		return group, nil
+	}
+	if err := q.DB.Model(&group).Updates(map[string]interface{}{
+		"max_per_node": perNode,
+		"max_total":    total,
+		"updated_by":   user,
+	}).Error; err != nil {
+		return group, fmt.Errorf("Updates %v", err)
+	}
+	group.MaxPerNode = perNode
+	group.MaxTotal = total
+	group.UpdatedBy = user
+	return group, nil
+}
+
+// GroupLimits to get the concurrency limits of the groups of an environment by name
+func (q *Queries) GroupLimits(ctx context.Context, envid uint) (map[string]QueryGroup, error) {
+	var groups []QueryGroup
+	if err := q.DB.WithContext(ctx).Where("environment_id = ?", envid).Find(&groups).Error; err != nil {
+		return nil, fmt.Errorf("Find %v", err)
+	}
+	limits := make(map[string]QueryGroup, len(groups))
+	for _, g := range groups {
+		limits[g.Name] = g
+	}
+	return limits, nil
+}
+
+// Helper to count the pending entries of the groups of an environment, in flight or waiting for a node
+func (q *Queries) groupCounts(ctx context.Context, envid uint, groups []string, inflight bool, now time.Time) (map[string]int64, error) {
+	counts := make(map[string]int64)
+	if len(groups) == 0 {
+		return counts, nil
+	}
+	cutoff := now.Add(-GroupInflightTimeout)
+	tx := q.DB.WithContext(ctx).Model(&NodeQuery{}).
+		Select("query_group, COUNT(*) AS total").
+		Where("environment_id = ? AND query_group IN ?", envid, groups)
+	if inflight {
+		tx = tx.Where("dispatched_at > ?", cutoff)
+	} else {
+		tx = tx.Where("(dispatched_at IS NULL OR dispatched_at <= ?)", cutoff)
+	}
+	var rows []groupCount
+	if err := tx.Group("query_group").Scan(&rows).Error; err != nil {
+		return counts, fmt.Errorf("Scan %v", err)
+	}
+	for _, r := range rows {
+		counts[r.QueryGroup] = r.Total
+	}
+	return counts, nil
+}
+
+// GroupsUtilization to get the groups of an environment, with limits or active queries, and how many
+// of their queries are in flight or waiting for a slot
+func (q *Queries) GroupsUtilization(ctx context.Context, envid uint) ([]GroupUtilization, error) {
+	limits, err := q.GroupLimits(ctx, envid)
+	if err != nil {
+		return nil, err
+	}
+	var active []DistributedQuery
+	if err := q.read().WithContext(ctx).Select("name", "query_group").
+		Where("active = ? AND environment_id = ? AND query_group <> ?", true, envid, "").
+		Order("priority, id").Find(&active).Error; err != nil {
+		return nil, fmt.Errorf("Find %v", err)
+	}
+	byName := make(map[string]*GroupUtilization)
+	var names []string
+	add := func(name string) *GroupUtilization {
+		if g, ok := byName[name]; ok {
+			return g
+		}
+		g := &GroupUtilization{Name: name, MaxPerNode: limits[name].MaxPerNode, MaxTotal: limits[name].MaxTotal}
+		byName[name] = g
+		names = append(names, name)
+		return g
+	}
+	for name := range limits {
+		add(name)
+	}
+	for _, a := range active {
+		g := add(a.Group)
+		g.Queries = append(g.Queries, a.Name)
+	}
+	sort.Strings(names)
+	now := time.Now()
+	inflight, err := q.groupCounts(ctx, envid, names, true, now)
+	if err != nil {
+		return nil, err
+	}
+	waiting, err := q.groupCounts(ctx, envid, names, false, now)
+	if err != nil {
+		return nil, err
+	}
+	groups := make([]GroupUtilization, 0, len(names))
+	for _, name := range names {
+		g := byName[name]
+		g.InFlight = inflight[name]
+		g.Waiting = waiting[name]
+		groups = append(groups, *g)
+	}
+	return groups, nil
+}
+
+// Helper to check if any of the pending entries of a node belongs to a group
+func hasGroups(pending []NodeQuery) bool {
+	for _, p := range pending {
+		if p.Group != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// groupDispatch to decide which pending entries are handed to a node. Grouped entries go in priority order and
+// are deferred once the node or the fleet reaches the limits of their group, and entries already in flight are
+// handed again until the node answers. It returns the entries to hand and the IDs of the newly dispatched ones.
+func groupDispatch(pending []NodeQuery, limits map[string]QueryGroup, fleet map[string]int64, now time.Time) ([]NodeQuery, []uint) {
+	cutoff := now.Add(-GroupInflightTimeout)
+	ordered := make([]NodeQuery, len(pending))
+	copy(ordered, pending)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		if ordered[i].Priority != ordered[j].Priority {
+			return ordered[i].Priority < ordered[j].Priority
+		}
+		return ordered[i].ID < ordered[j].ID
+	})
+	perNode := make(map[string]int)
+	for _, p := range ordered {
+		if p.Group != "" && p.DispatchedAt.After(cutoff) {
+			perNode[p.Group]++
+		}
+	}
+	inflight := make(map[string]int64, len(fleet))
+	for g, c := range fleet {
+		inflight[g] = c
+	}
+	var handed []NodeQuery
+	var dispatched []uint
+	for _, p := range ordered {
+		if p.Group == "" || p.DispatchedAt.After(cutoff) {
+			handed = append(handed, p)
+			continue
+		}
+		limit := limits[p.Group]
+		if limit.MaxPerNode > 0 && perNode[p.Group] >= limit.MaxPerNode {
+			continue
+		}
+		if limit.MaxTotal > 0 && inflight[p.Group] >= int64(limit.MaxTotal) {
+			continue
+		}
+		perNode[p.Group]++
+		inflight[p.Group]++
+		handed = append(handed, p)
+		dispatched = append(dispatched, p.ID)
+	}
+	return handed, dispatched
+}
+
+// Helper to apply the limits of the groups to the pending entries of a node, marking the handed ones as in flight.
+// Nodes checking in at the same time may briefly go over the limit of a group across the fleet.
+func (q *Queries) dispatchGroups(ctx context.Context, envid uint, pending []NodeQuery) ([]NodeQuery, error) {
+	limits, err := q.GroupLimits(ctx, envid)
+	if err != nil {
+		return nil, err
+	}
+	var totals []string
+	for name, l := range limits {
+		if l.MaxTotal > 0 {
+			totals = append(totals, name)
+		}
+	}
+	now := time.Now()
+	fleet, err := q.groupCounts(ctx, envid, totals, true, now)
+	if err != nil {
+		return nil, err
+	}
+	handed, dispatched := groupDispatch(pending, limits, fleet, now)
+	if len(dispatched) > 0 {
+		if err := q.DB.WithContext(ctx).Model(&NodeQuery{}).Where("id IN ?", dispatched).Update("dispatched_at", now).Error; err != nil {
+			return nil, fmt.Errorf("Update %v", err)
+		}
+	}
+	return handed, nil
+}
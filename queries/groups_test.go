@@ -0,0 +1,78 @@
+package queries
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func handedNames(handed []NodeQuery) []string {
+	var names []string
+	for _, h := range handed {
+		names = append(names, h.Name)
+	}
+	return names
+}
+
+func TestValidateGroup(t *testing.T) {
+	assert.NoError(t, ValidateGroup(""))
+	assert.NoError(t, ValidateGroup("hunt-2024.ir_1"))
+	assert.Error(t, ValidateGroup("hunt 1"))
+	assert.Error(t, ValidateGroup("hunt/1"))
+	assert.Error(t, ValidateGroup(string(make([]byte, MaxGroupName+1))))
+}
+
+func TestGroupDispatchUngrouped(t *testing.T) {
+	now := time.Now()
+	pending := []NodeQuery{{ID: 1, Name: "query-1"}, {ID: 2, Name: "query-2"}}
+	handed, dispatched := groupDispatch(pending, map[string]QueryGroup{}, nil, now)
+	assert.ElementsMatch(t, []string{"query-1", "query-2"}, handedNames(handed))
+	assert.Empty(t, dispatched)
+}
+
+func TestGroupDispatchPerNode(t *testing.T) {
+	now := time.Now()
+	limits := map[string]QueryGroup{"hunt": {Name: "hunt", MaxPerNode: 2}}
+	pending := []NodeQuery{
+		{ID: 1, Name: "query-1", Group: "hunt", Priority: 5},
+		{ID: 2, Name: "query-2", Group: "hunt", Priority: 1},
+		{ID: 3, Name: "query-3", Group: "hunt", Priority: 1},
+		{ID: 4, Name: "query-4"},
+	}
+	handed, dispatched := groupDispatch(pending, limits, nil, now)
+	assert.ElementsMatch(t, []string{"query-2", "query-3", "query-4"}, handedNames(handed))
+	assert.Equal(t, []uint{2, 3}, dispatched)
+	// In flight entries are handed again and take the slots of the node
+	pending[1].DispatchedAt = now.Add(-time.Minute)
+	pending[2].DispatchedAt = now.Add(-time.Minute)
+	handed, dispatched = groupDispatch(pending, limits, nil, now)
+	assert.ElementsMatch(t, []string{"query-2", "query-3", "query-4"}, handedNames(handed))
+	assert.Empty(t, dispatched)
+	// Entries in flight for too long free their slot
+	pending[2].DispatchedAt = now.Add(-GroupInflightTimeout - time.Minute)
+	handed, dispatched = groupDispatch(pending, limits, nil, now)
+	assert.ElementsMatch(t, []string{"query-2", "query-3", "query-4"}, handedNames(handed))
+	assert.Equal(t, []uint{3}, dispatched)
+}
+
+func TestGroupDispatchFleet(t *testing.T) {
+	now := time.Now()
+	limits := map[string]QueryGroup{"hunt": {Name: "hunt", MaxTotal: 10}, "other": {Name: "other", MaxPerNode: 1}}
+	pending := []NodeQuery{
+		{ID: 1, Name: "query-1", Group: "hunt"},
+		{ID: 2, Name: "query-2", Group: "hunt"},
+		{ID: 3, Name: "query-3", Group: "other"},
+		{ID: 4, Name: "query-4", Group: "unlimited"},
+	}
+	handed, dispatched := groupDispatch(pending, limits, map[string]int64{"hunt": 9}, now)
+	assert.ElementsMatch(t, []string{"query-1", "query-3", "query-4"}, handedNames(handed))
+	assert.Equal(t, []uint{1, 3, 4}, dispatched)
+	handed, _ = groupDispatch(pending, limits, map[string]int64{"hunt": 10}, now)
+	assert.ElementsMatch(t, []string{"query-3", "query-4"}, handedNames(handed))
+}
+
+func TestHasGroups(t *testing.T) {
+	assert.False(t, hasGroups([]NodeQuery{{Name: "query-1"}}))
+	assert.True(t, hasGroups([]NodeQuery{{Name: "query-1"}, {Name: "query-2", Group: "hunt"}}))
+}
@@ -0,0 +1,392 @@
+package queries
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+const (
+	// ParquetRowGroup is the default number of rows in each row group of Parquet files
+	ParquetRowGroup int = 10000
+	// Magic bytes at the beginning and the end of Parquet files
+	parquetMagic string = "PAR1"
+)
+
+// Parquet physical types
+const (
+	parquetBoolean   int32 = 0
+	parquetInt64     int32 = 2
+	parquetDouble    int32 = 5
+	parquetByteArray int32 = 6
+)
+
+// Parquet converted types, repetitions, encodings, codecs and pages
+const (
+	parquetUTF8            int32 = 0
+	parquetTimestampMillis int32 = 9
+	parquetRequired        int32 = 0
+	parquetOptional        int32 = 1
+	parquetPlain           int32 = 0
+	parquetRLE             int32 = 3
+	parquetUncompressed    int32 = 0
+	parquetDataPage        int32 = 0
+)
+
+// Thrift compact protocol types
+const (
+	thriftI32    byte = 5
+	thriftI64    byte = 6
+	thriftBinary byte = 8
+	thriftList   byte = 9
+	thriftStruct byte = 12
+)
+
+// Helper to write thrift structures with the compact protocol, as used by the Parquet metadata
+type thriftWriter struct {
+	buf    bytes.Buffer
+	lastID int16
+	stack  []int16
+}
+
+func (t *thriftWriter) uvarint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	t.buf.Write(b[:n])
+}
+
+func (t *thriftWriter) zigzag(v int64) {
+	t.uvarint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	if delta := id - t.lastID; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.buf.WriteByte(typ)
+		t.zigzag(int64(id))
+	}
+	t.lastID = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.zigzag(int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.zigzag(v)
+}
+
+func (t *thriftWriter) str(id int16, v string) {
+	t.field(id, thriftBinary)
+	t.uvarint(uint64(len(v)))
+	t.buf.WriteString(v)
+}
+
+func (t *thriftWriter) list(id int16, elem byte, size int) {
+	t.field(id, thriftList)
+	if size < 15 {
+		t.buf.WriteByte(byte(size)<<4 | elem)
+	} else {
+		t.buf.WriteByte(0xf0 | elem)
+		t.uvarint(uint64(size))
+	}
+}
+
+// begin a struct, as field or as element of a list when id is 0
+func (t *thriftWriter) begin(id int16) {
+	if id > 0 {
+		t.field(id, thriftStruct)
+	}
+	t.stack = append(t.stack, t.lastID)
+	t.lastID = 0
+}
+
+func (t *thriftWriter) end() {
+	t.buf.WriteByte(0)
+	if n := len(t.stack); n > 0 {
+		t.lastID = t.stack[n-1]
+		t.stack = t.stack[:n-1]
+	}
+}
+
+// Helper to count the bytes written, for the offsets of pages
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Column of a Parquet file with the values of the current row group, nil for nulls
+type parquetColumn struct {
+	name     string
+	source   string
+	kind     string
+	required bool
+	values   []interface{}
+}
+
+// Metadata of a column chunk already written
+type parquetChunk struct {
+	offset int64
+	size   int64
+	values int64
+}
+
+// Metadata of a row group already written
+type parquetRowGroup struct {
+	chunks []parquetChunk
+	rows   int64
+}
+
+// ParquetWriter to write rows of results as a Parquet file with typed columns, without compression.
+// Rows are buffered by row group, so memory stays bounded for large results.
+type ParquetWriter struct {
+	w            *countingWriter
+	columns      []*parquetColumn
+	rowGroupSize int
+	rows         int
+	rowGroups    []parquetRowGroup
+	numRows      int64
+}
+
+// NewParquetWriter to start a Parquet file with the node and collection time, followed by the columns of the schema
+func NewParquetWriter(w io.Writer, schema ResultSchema, rowGroupSize int) (*ParquetWriter, error) {
+	if rowGroupSize <= 0 {
+		rowGroupSize = ParquetRowGroup
+	}
+	p := &ParquetWriter{w: &countingWriter{w: w}, rowGroupSize: rowGroupSize}
+	p.columns = append(p.columns,
+		&parquetColumn{name: ExportNodeColumn, kind: ColumnString, required: true},
+		&parquetColumn{name: ExportTimeColumn, kind: ColumnTimestamp, required: true},
+	)
+	for _, c := range exportColumns(schema) {
+		p.columns = append(p.columns, &parquetColumn{name: c.Name, source: c.Source, kind: c.Type})
+	}
+	if _, err := io.WriteString(p.w, parquetMagic); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Write to add one row of results of a node, missing columns are nulls
+func (p *ParquetWriter) Write(node string, collected time.Time, row map[string]string) error {
+	p.columns[0].values = append(p.columns[0].values, node)
+	p.columns[1].values = append(p.columns[1].values, collected.UTC())
+	for _, c := range p.columns[2:] {
+		var v interface{}
+		if value, ok := row[c.source]; ok {
+			v = TypedValue(c.kind, value)
+		}
+		c.values = append(c.values, v)
+	}
+	p.rows++
+	if p.rows >= p.rowGroupSize {
+		return p.flush()
+	}
+	return nil
+}
+
+// Close to write the pending rows and the footer of the file, it does not close the underlying writer
+func (p *ParquetWriter) Close() error {
+	if err := p.flush(); err != nil {
+		return err
+	}
+	meta := p.metadata()
+	if _, err := p.w.Write(meta); err != nil {
+		return err
+	}
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(len(meta)))
+	if _, err := p.w.Write(size[:]); err != nil {
+		return err
+	}
+	_, err := io.WriteString(p.w, parquetMagic)
+	return err
+}
+
+// Helper to write the buffered rows as a row group, with one data page for each column
+func (p *ParquetWriter) flush() error {
+	if p.rows == 0 {
+		return nil
+	}
+	group := parquetRowGroup{rows: int64(p.rows)}
+	for _, c := range p.columns {
+		page, err := c.page()
+		if err != nil {
+			return fmt.Errorf("column %s %v", c.name, err)
+		}
+		var header thriftWriter
+		header.i32(1, parquetDataPage)
+		header.i32(2, int32(len(page)))
+		header.i32(3, int32(len(page)))
+		header.begin(5)
+		header.i32(1, int32(len(c.values)))
+		header.i32(2, parquetPlain)
+		header.i32(3, parquetRLE)
+		header.i32(4, parquetRLE)
+		header.end()
+		header.end()
+		chunk := parquetChunk{offset: p.w.n, values: int64(len(c.values))}
+		if _, err := p.w.Write(header.buf.Bytes()); err != nil {
+			return err
+		}
+		if _, err := p.w.Write(page); err != nil {
+			return err
+		}
+		chunk.size = p.w.n - chunk.offset
+		group.chunks = append(group.chunks, chunk)
+		c.values = c.values[:0]
+	}
+	p.rowGroups = append(p.rowGroups, group)
+	p.numRows += group.rows
+	p.rows = 0
+	return nil
+}
+
+// Helper to encode the values of a column as a data page, definition levels first for optional columns
+func (c *parquetColumn) page() ([]byte, error) {
+	var buf bytes.Buffer
+	if !c.required {
+		// Definition levels with bit width 1, as one bit-packed run
+		groups := (len(c.values) + 7) / 8
+		var levels thriftWriter
+		levels.uvarint(uint64(groups<<1 | 1))
+		packed := make([]byte, groups)
+		for i, v := range c.values {
+			if v != nil {
+				packed[i/8] |= 1 << uint(i%8)
+			}
+		}
+		levels.buf.Write(packed)
+		var size [4]byte
+		binary.LittleEndian.PutUint32(size[:], uint32(levels.buf.Len()))
+		buf.Write(size[:])
+		buf.Write(levels.buf.Bytes())
+	}
+	var b8 [8]byte
+	var bits []byte
+	n := 0
+	for _, v := range c.values {
+		if v == nil {
+			if c.required {
+				return nil, fmt.Errorf("null value in required column")
+			}
+			continue
+		}
+		switch value := v.(type) {
+		case int64:
+			binary.LittleEndian.PutUint64(b8[:], uint64(value))
+			buf.Write(b8[:])
+		case float64:
+			binary.LittleEndian.PutUint64(b8[:], math.Float64bits(value))
+			buf.Write(b8[:])
+		case time.Time:
+			binary.LittleEndian.PutUint64(b8[:], uint64(value.UnixNano()/int64(time.Millisecond)))
+			buf.Write(b8[:])
+		case bool:
+			if n%8 == 0 {
+				bits = append(bits, 0)
+			}
+			if value {
+				bits[n/8] |= 1 << uint(n%8)
+			}
+			n++
+		case string:
+			binary.LittleEndian.PutUint32(b8[:4], uint32(len(value)))
+			buf.Write(b8[:4])
+			buf.WriteString(value)
+		default:
+			return nil, fmt.Errorf("unsupported value %T", v)
+		}
+	}
+	buf.Write(bits)
+	return buf.Bytes(), nil
+}
+
+// Helper to get the physical and converted types of a column
+func parquetTypes(kind string) (int32, int32, bool) {
+	switch kind {
+	case ColumnInt:
+		return parquetInt64, 0, false
+	case ColumnFloat:
+		return parquetDouble, 0, false
+	case ColumnBool:
+		return parquetBoolean, 0, false
+	case ColumnTimestamp:
+		return parquetInt64, parquetTimestampMillis, true
+	}
+	return parquetByteArray, parquetUTF8, true
+}
+
+// Helper to serialize the metadata of the file for the footer
+func (p *ParquetWriter) metadata() []byte {
+	var t thriftWriter
+	t.i32(1, 1)
+	// Schema as a flat list, with the root element first
+	t.list(2, thriftStruct, len(p.columns)+1)
+	t.begin(0)
+	t.str(4, "schema")
+	t.i32(5, int32(len(p.columns)))
+	t.end()
+	for _, c := range p.columns {
+		physical, converted, hasConverted := parquetTypes(c.kind)
+		repetition := parquetOptional
+		if c.required {
+			repetition = parquetRequired
+		}
+		t.begin(0)
+		t.i32(1, physical)
+		t.i32(3, repetition)
+		t.str(4, c.name)
+		if hasConverted {
+			t.i32(6, converted)
+		}
+		t.end()
+	}
+	t.i64(3, p.numRows)
+	t.list(4, thriftStruct, len(p.rowGroups))
+	for _, g := range p.rowGroups {
+		t.begin(0)
+		t.list(1, thriftStruct, len(g.chunks))
+		var total int64
+		for i, chunk := range g.chunks {
+			c := p.columns[i]
+			physical, _, _ := parquetTypes(c.kind)
+			t.begin(0)
+			t.i64(2, chunk.offset)
+			t.begin(3)
+			t.i32(1, physical)
+			t.list(2, thriftI32, 2)
+			t.zigzag(int64(parquetPlain))
+			t.zigzag(int64(parquetRLE))
+			t.list(3, thriftBinary, 1)
+			t.uvarint(uint64(len(c.name)))
+			t.buf.WriteString(c.name)
+			t.i32(4, parquetUncompressed)
+			t.i64(5, chunk.values)
+			t.i64(6, chunk.size)
+			t.i64(7, chunk.size)
+			t.i64(9, chunk.offset)
+			t.end()
+			t.end()
+			total += chunk.size
+		}
+		t.i64(2, total)
+		t.i64(3, g.rows)
+		t.end()
+	}
+	t.str(6, "osctrl")
+	t.buf.WriteByte(0)
+	return t.buf.Bytes()
+}
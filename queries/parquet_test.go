@@ -0,0 +1,271 @@
+package queries
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Minimal reader of thrift compact structures, to decode the Parquet metadata written by the exporter
+type thriftReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *thriftReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.data[r.pos:])
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) zigzag() int64 {
+	v := r.uvarint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *thriftReader) value(typ byte) interface{} {
+	switch typ {
+	case 1:
+		return true
+	case 2:
+		return false
+	case 5, 6:
+		return r.zigzag()
+	case 8:
+		n := int(r.uvarint())
+		s := string(r.data[r.pos : r.pos+n])
+		r.pos += n
+		return s
+	case 9:
+		header := r.data[r.pos]
+		r.pos++
+		size := int(header >> 4)
+		if size == 15 {
+			size = int(r.uvarint())
+		}
+		list := []interface{}{}
+		for i := 0; i < size; i++ {
+			list = append(list, r.value(header&0x0f))
+		}
+		return list
+	case 12:
+		return r.structure()
+	}
+	panic(fmt.Sprintf("unsupported thrift type %d", typ))
+}
+
+func (r *thriftReader) structure() map[int16]interface{} {
+	fields := make(map[int16]interface{})
+	var last int16
+	for {
+		header := r.data[r.pos]
+		r.pos++
+		if header == 0 {
+			return fields
+		}
+		id := last + int16(header>>4)
+		if header>>4 == 0 {
+			id = int16(r.zigzag())
+		}
+		fields[id] = r.value(header & 0x0f)
+		last = id
+	}
+}
+
+// Helper to read a Parquet file back as rows of values by column name, nil for nulls
+func readParquet(t *testing.T, data []byte) ([]map[int16]interface{}, []map[string]interface{}, int) {
+	assert.Equal(t, "PAR1", string(data[:4]))
+	assert.Equal(t, "PAR1", string(data[len(data)-4:]))
+	size := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	meta := (&thriftReader{data: data[len(data)-8-size : len(data)-8]}).structure()
+	schema := []map[int16]interface{}{}
+	for _, e := range meta[2].([]interface{})[1:] {
+		schema = append(schema, e.(map[int16]interface{}))
+	}
+	rows := []map[string]interface{}{}
+	groups := meta[4].([]interface{})
+	for _, g := range groups {
+		group := g.(map[int16]interface{})
+		numRows := int(group[3].(int64))
+		groupRows := make([]map[string]interface{}, numRows)
+		for i := range groupRows {
+			groupRows[i] = make(map[string]interface{})
+		}
+		for i, c := range group[1].([]interface{}) {
+			cm := c.(map[int16]interface{})[3].(map[int16]interface{})
+			element := schema[i]
+			name := cm[3].([]interface{})[0].(string)
+			assert.Equal(t, element[4].(string), name)
+			r := &thriftReader{data: data, pos: int(cm[9].(int64))}
+			header := r.structure()
+			page := data[r.pos : r.pos+int(header[3].(int64))]
+			present := make([]bool, numRows)
+			pos := 0
+			if element[3].(int64) == int64(parquetOptional) {
+				levels := int(binary.LittleEndian.Uint32(page))
+				lr := &thriftReader{data: page[4 : 4+levels]}
+				assert.Equal(t, uint64(1), lr.uvarint()&1)
+				for j := range present {
+					present[j] = lr.data[lr.pos+j/8]&(1<<uint(j%8)) != 0
+				}
+				pos = 4 + levels
+			} else {
+				for j := range present {
+					present[j] = true
+				}
+			}
+			bit := 0
+			for j := 0; j < numRows; j++ {
+				if !present[j] {
+					groupRows[j][name] = nil
+					continue
+				}
+				switch element[1].(int64) {
+				case int64(parquetInt64):
+					v := int64(binary.LittleEndian.Uint64(page[pos:]))
+					pos += 8
+					if converted, ok := element[6]; ok && converted.(int64) == int64(parquetTimestampMillis) {
+						groupRows[j][name] = time.Unix(0, v*int64(time.Millisecond)).UTC()
+					} else {
+						groupRows[j][name] = v
+					}
+				case int64(parquetDouble):
+					groupRows[j][name] = math.Float64frombits(binary.LittleEndian.Uint64(page[pos:]))
+					pos += 8
+				case int64(parquetBoolean):
+					groupRows[j][name] = page[pos+bit/8]&(1<<uint(bit%8)) != 0
+					bit++
+				case int64(parquetByteArray):
+					n := int(binary.LittleEndian.Uint32(page[pos:]))
+					groupRows[j][name] = string(page[pos+4 : pos+4+n])
+					pos += 4 + n
+				}
+			}
+		}
+		rows = append(rows, groupRows...)
+	}
+	assert.Equal(t, int64(len(rows)), meta[3].(int64))
+	return schema, rows, len(groups)
+}
+
+func TestSchemaInference(t *testing.T) {
+	s := NewSchemaInference()
+	s.Add(map[string]string{"pid": "1", "size": "10", "active": "true", "ratio": "0.5", "seen": "2023-01-02T03:04:05Z", "name": "a"})
+	s.Add(map[string]string{"pid": "2", "size": "1.5", "active": "false", "ratio": "", "seen": "2023-01-02 03:04:05", "name": "12"})
+	s.Add(map[string]string{"pid": "3", "size": "x", "name": "b"})
+	schema := s.Schema()
+	assert.Equal(t, 3, schema.Rows)
+	assert.Equal(t, []ResultColumn{
+		{Name: "active", Type: ColumnBool, Nulls: 1},
+		{Name: "name", Type: ColumnString, Nulls: 0},
+		{Name: "pid", Type: ColumnInt, Nulls: 0},
+		{Name: "ratio", Type: ColumnFloat, Nulls: 2},
+		{Name: "seen", Type: ColumnTimestamp, Nulls: 1},
+		{Name: "size", Type: ColumnString, Nulls: 0},
+	}, schema.Columns)
+	assert.Equal(t, ColumnString, ValueType("nan"))
+	assert.Equal(t, ColumnString, ValueType("Inf"))
+	assert.Equal(t, ColumnFloat, ValueType("1e3"))
+}
+
+func TestParseResultRows(t *testing.T) {
+	rows, err := ParseResultRows(json.RawMessage(`[{"pid":"1","uid":501,"path":null}]`))
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]string{{"pid": "1", "uid": "501"}}, rows)
+	rows, err = ParseResultRows(json.RawMessage(`""`))
+	assert.NoError(t, err)
+	assert.Empty(t, rows)
+	_, err = ParseResultRows(json.RawMessage(`{`))
+	assert.Error(t, err)
+}
+
+func TestParquetWriter(t *testing.T) {
+	results := []map[string]string{
+		{"pid": "1", "name": "launchd", "cpu": "0.5", "root": "true", "started": "2023-01-02T03:04:05Z"},
+		{"pid": "2", "name": "kernel", "cpu": "1"},
+		{"pid": "3", "name": "", "root": "false", "node_uuid": "inner"},
+	}
+	s := NewSchemaInference()
+	for _, r := range results {
+		s.Add(r)
+	}
+	collected := time.Date(2023, 1, 2, 10, 0, 0, 0, time.UTC)
+	var buf bytes.Buffer
+	p, err := NewParquetWriter(&buf, s.Schema(), 2)
+	assert.NoError(t, err)
+	for i, r := range results {
+		assert.NoError(t, p.Write(fmt.Sprintf("NODE-%d", i), collected, r))
+	}
+	assert.NoError(t, p.Close())
+	schema, rows, groups := readParquet(t, buf.Bytes())
+	// Row groups are written as they fill up
+	assert.Equal(t, 2, groups)
+	names := []string{}
+	for _, e := range schema {
+		names = append(names, e[4].(string))
+	}
+	assert.Equal(t, []string{"node_uuid", "collected_at", "cpu", "name", "result_node_uuid", "pid", "root", "started"}, names)
+	assert.Equal(t, int64(parquetRequired), schema[0][3])
+	assert.Equal(t, int64(parquetDouble), schema[2][1])
+	assert.Equal(t, int64(parquetByteArray), schema[3][1])
+	assert.Equal(t, int64(parquetInt64), schema[5][1])
+	assert.Equal(t, int64(parquetBoolean), schema[6][1])
+	assert.Equal(t, int64(parquetTimestampMillis), schema[7][6])
+	assert.Equal(t, 3, len(rows))
+	assert.Equal(t, map[string]interface{}{
+		"node_uuid":        "NODE-0",
+		"collected_at":     collected,
+		"cpu":              0.5,
+		"name":             "launchd",
+		"result_node_uuid": nil,
+		"pid":              int64(1),
+		"root":             true,
+		"started":          time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC),
+	}, rows[0])
+	assert.Equal(t, 1.0, rows[1]["cpu"])
+	assert.Nil(t, rows[1]["root"])
+	assert.Nil(t, rows[1]["started"])
+	assert.Equal(t, "", rows[2]["name"])
+	assert.Equal(t, "inner", rows[2]["result_node_uuid"])
+	assert.Equal(t, false, rows[2]["root"])
+	assert.Nil(t, rows[2]["cpu"])
+}
+
+func TestParquetWriterEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	p, err := NewParquetWriter(&buf, NewSchemaInference().Schema(), 0)
+	assert.NoError(t, err)
+	assert.NoError(t, p.Close())
+	schema, rows, groups := readParquet(t, buf.Bytes())
+	assert.Equal(t, 2, len(schema))
+	assert.Empty(t, rows)
+	assert.Equal(t, 0, groups)
+}
+
+func TestResultExporters(t *testing.T) {
+	schema := ResultSchema{Rows: 1, Columns: []ResultColumn{{Name: "pid", Type: ColumnInt}, {Name: "root", Type: ColumnBool, Nulls: 1}}}
+	collected := time.Date(2023, 1, 2, 10, 0, 0, 0, time.UTC)
+	var buf bytes.Buffer
+	e, err := NewResultExporter(&buf, ExportCSV, schema)
+	assert.NoError(t, err)
+	assert.NoError(t, e.Write("NODE", collected, map[string]string{"pid": "7"}))
+	assert.NoError(t, e.Close())
+	assert.Equal(t, "node_uuid,collected_at,pid,root\nNODE,2023-01-02T10:00:00Z,7,\n", buf.String())
+	buf.Reset()
+	e, err = NewResultExporter(&buf, ExportJSON, schema)
+	assert.NoError(t, err)
+	assert.NoError(t, e.Write("NODE", collected, map[string]string{"pid": "7"}))
+	assert.NoError(t, e.Write("NODE", collected, map[string]string{"pid": "8", "root": "true"}))
+	assert.NoError(t, e.Close())
+	assert.JSONEq(t, `[{"node_uuid":"NODE","collected_at":"2023-01-02T10:00:00Z","pid":7,"root":null},{"node_uuid":"NODE","collected_at":"2023-01-02T10:00:00Z","pid":8,"root":true}]`, buf.String())
+	_, err = NewResultExporter(&buf, "xml", schema)
+	assert.Error(t, err)
+	assert.True(t, ValidExportFormat(ExportParquet))
+	assert.False(t, ValidExportFormat("xml"))
+}
@@ -0,0 +1,243 @@
+package queries
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmpsec/osctrl/nodes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Number of pending queries inserted at once when materializing
+const pendingBatchSize = 1000
+
+// NodeQuery to keep one on-demand query pending for a node. Entries are materialized when the targets
+// of a query are evaluated, at creation or activation of the query and at enrollment of the node,
+// and removed when the node answers or the query is completed or deleted. Reading the queries of a
+// node in each check-in is then one indexed lookup, instead of evaluating all the active queries.
+type NodeQuery struct {
+	ID            uint `gorm:"primarykey"`
+	CreatedAt     time.Time
+	NodeID        uint `gorm:"uniqueIndex:idx_node_queries_node_name"`
+	UUID          string
+	Name          string `gorm:"uniqueIndex:idx_node_queries_node_name;index"`
+	EnvironmentID uint
+	Query         string
+	Type          string
+	SampleSize    int
+	Accelerate    bool
+	Group         string `gorm:"column:query_group;index"`
+	Priority      int
+	DispatchedAt  time.Time
+}
+
+// Helper to get the SQL condition for the nodes matching the targets of a query
+func targetsCondition(targets []DistributedQueryTarget) (string, []interface{}) {
+	columns := map[string]string{
+		QueryTargetEnvironment: "environment",
+		QueryTargetPlatform:    "platform",
+		QueryTargetUUID:        "uuid",
+		QueryTargetLocalname:   "localname",
+	}
+	// Keep the order of the types for a stable condition
+	order := []string{QueryTargetEnvironment, QueryTargetPlatform, QueryTargetUUID, QueryTargetLocalname}
+	values := make(map[string][]string)
+	for _, t := range targets {
+		if _, ok := columns[t.Type]; ok {
+			values[t.Type] = append(values[t.Type], t.Value)
+		}
+	}
+	var conditions []string
+	var args []interface{}
+	for _, tType := range order {
+		if len(values[tType]) == 0 {
+			continue
+		}
+		conditions = append(conditions, columns[tType]+" IN ?")
+		args = append(args, values[tType])
+	}
+	// Nodes with matching custom attributes are selected like the other targets
+	attrConditions, attrArgs := attributeConditions(targets)
+	conditions = append(conditions, attrConditions...)
+	args = append(args, attrArgs...)
+	// Time windows restrict the nodes of the other targets, or select nodes on their own
+	window, windowArgs := WindowOfTargets(targets).condition()
+	switch {
+	case len(conditions) == 0 && window == "":
+		return "", nil
+	case len(conditions) == 0:
+		return "(" + window + ")", windowArgs
+	case window == "":
+		return "(" + strings.Join(conditions, " OR ") + ")", args
+	}
+	return "(" + strings.Join(conditions, " OR ") + ") AND (" + window + ")", append(args, windowArgs...)
+}
+
+// Helper to record how many nodes of the environment match the targets of a query with a time window
+func (q *Queries) evaluateWindow(query DistributedQuery, targets []DistributedQueryTarget) error {
+	if WindowOfTargets(targets).Empty() {
+		return nil
+	}
+	condition, args := targetsCondition(targets)
+	var matched int64
+	err := q.DB.Model(&nodes.OsqueryNode{}).
+		Where("environment_id = ?", query.EnvironmentID).
+		Where(condition, args...).
+		Count(&matched).Error
+	if err != nil {
+		return fmt.Errorf("Count %v", err)
+	}
+	return q.SetWindowMatched(query.Name, query.EnvironmentID, int(matched))
+}
+
+// Helper to prepare the pending entry of a query for a node
+func pendingEntry(query DistributedQuery, node nodes.OsqueryNode, targets int) NodeQuery {
+	return NodeQuery{
+		NodeID:        node.ID,
+		UUID:          node.UUID,
+		Name:          query.Name,
+		EnvironmentID: query.EnvironmentID,
+		Query:         query.Query,
+		Type:          query.Type,
+		SampleSize:    query.SampleSize,
+		Accelerate:    targets == 1,
+		Group:         query.Group,
+		Priority:      query.Priority,
+	}
+}
+
+// Helper to insert pending entries, skipping the ones that already exist
+func (q *Queries) insertPending(tx *gorm.DB, entries []NodeQuery) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(&entries, pendingBatchSize).Error; err != nil {
+		return fmt.Errorf("CreateInBatches %v", err)
+	}
+	return nil
+}
+
+// Materialize to create the pending entries of an active query for all the targeted nodes that did not execute it yet.
+// It must be called once the targets of the query are created, and returns the number of nodes targeted.
+func (q *Queries) Materialize(name string, envid uint) (int, error) {
+	query, err := q.Get(name, envid)
+	if err != nil {
+		return 0, err
+	}
+	if !query.Active {
+		return 0, nil
+	}
+	targets, err := q.GetTargets(name)
+	if err != nil {
+		return 0, err
+	}
+	condition, args := targetsCondition(targets)
+	if condition == "" {
+		return 0, nil
+	}
+	if err := q.evaluateWindow(query, targets); err != nil {
+		return 0, err
+	}
+	var targeted []nodes.OsqueryNode
+	err = q.DB.Model(&nodes.OsqueryNode{}).Select("id", "uuid").
+		Where("environment_id = ?", envid).
+		Where(condition, args...).
+		Where("uuid NOT IN (?)", q.DB.Model(&DistributedQueryExecution{}).Select("uuid").Where("name = ?", name)).
+		Find(&targeted).Error
+	if err != nil {
+		return 0, fmt.Errorf("Find %v", err)
+	}
+	entries := make([]NodeQuery, 0, len(targeted))
+	for _, n := range targeted {
+		entries = append(entries, pendingEntry(query, n, len(targets)))
+	}
+	if err := q.insertPending(q.DB, entries); err != nil {
+		return 0, err
+	}
+	return len(entries), nil
+}
+
+// MaterializeNode to replace the pending entries of a node with the active queries of its environment that target it,
+// used when the node enrolls or changes the values that queries can target
+func (q *Queries) MaterializeNode(node nodes.OsqueryNode) (int, error) {
+	active, err := q.GetActive(node.EnvironmentID)
+	if err != nil {
+		return 0, err
+	}
+	var entries []NodeQuery
+	for _, query := range active {
+		targets, err := q.GetTargets(query.Name)
+		if err != nil {
+			return 0, err
+		}
+		if !isQueryTarget(node, targets) {
+			continue
+		}
+		// Nodes entering the window of a query change how many nodes it matches
+		if err := q.evaluateWindow(query, targets); err != nil {
+			return 0, err
+		}
+		if q.NotYetExecuted(query.Name, node.UUID) {
+			entries = append(entries, pendingEntry(query, node, len(targets)))
+		}
+	}
+	err = q.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("node_id = ?", node.ID).Delete(&NodeQuery{}).Error; err != nil {
+			return fmt.Errorf("Delete %v", err)
+		}
+		return q.insertPending(tx, entries)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(entries), nil
+}
+
+// MaterializeActive to create the pending entries of all the active queries, for queries in flight before
+// pending entries existed. Entries that already exist are kept.
+func (q *Queries) MaterializeActive() (int, error) {
+	var active []DistributedQuery
+	if err := q.DB.Where("active = ?", true).Find(&active).Error; err != nil {
+		return 0, fmt.Errorf("Find %v", err)
+	}
+	total := 0
+	for _, query := range active {
+		n, err := q.Materialize(query.Name, query.EnvironmentID)
+		if err != nil {
+			return total, fmt.Errorf("Materialize %s %v", query.Name, err)
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// ClearPending to remove all the pending entries of a query
+func (q *Queries) ClearPending(name string) error {
+	if err := q.DB.Where("name = ?", name).Delete(&NodeQuery{}).Error; err != nil {
+		return fmt.Errorf("Delete %v", err)
+	}
+	return nil
+}
+
+// NodePending to get the pending entries of a node, reading from the primary since answers remove them
+func (q *Queries) NodePending(ctx context.Context, nodeid uint) ([]NodeQuery, error) {
+	var pending []NodeQuery
+	if err := q.DB.WithContext(ctx).Where("node_id = ?", nodeid).Find(&pending).Error; err != nil {
+		return pending, err
+	}
+	return pending, nil
+}
+
+// PausedTargets to get the UUIDs of the nodes with pending entries of a query that are paused at a given time
+func (q *Queries) PausedTargets(name string, now time.Time) ([]string, error) {
+	var uuids []string
+	paused := q.DB.Model(&nodes.NodePause{}).Select("uuid").
+		Where("active = ? AND (expires_at <= ? OR expires_at > ?)", true, time.Time{}, now)
+	if err := q.DB.Model(&NodeQuery{}).Where("name = ? AND uuid IN (?)", name, paused).Pluck("uuid", &uuids).Error; err != nil {
+		return uuids, fmt.Errorf("Pluck %v", err)
+	}
+	return uuids, nil
+}
@@ -0,0 +1,93 @@
+package queries
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTargetsCondition(t *testing.T) {
+	condition, args := targetsCondition(nil)
+	assert.Equal(t, "", condition)
+	assert.Nil(t, args)
+	targets := []DistributedQueryTarget{
+		{Type: QueryTargetUUID, Value: "uuid-1"},
+		{Type: QueryTargetPlatform, Value: "darwin"},
+		{Type: QueryTargetUUID, Value: "uuid-2"},
+		{Type: "unknown", Value: "value"},
+	}
+	condition, args = targetsCondition(targets)
+	assert.Equal(t, "(platform IN ? OR uuid IN ?)", condition)
+	assert.Equal(t, []interface{}{[]string{"darwin"}, []string{"uuid-1", "uuid-2"}}, args)
+}
+
+func TestPendingEntry(t *testing.T) {
+	query := DistributedQuery{Name: "query-1", Query: "SELECT 1;", Type: StandardQueryType, SampleSize: 2, EnvironmentID: 3, Group: "hunt", Priority: 4}
+	node := nodes.OsqueryNode{UUID: "uuid-1"}
+	node.ID = 7
+	entry := pendingEntry(query, node, 1)
+	assert.Equal(t, uint(7), entry.NodeID)
+	assert.Equal(t, "uuid-1", entry.UUID)
+	assert.Equal(t, "query-1", entry.Name)
+	assert.Equal(t, uint(3), entry.EnvironmentID)
+	assert.Equal(t, 2, entry.SampleSize)
+	assert.Equal(t, "hunt", entry.Group)
+	assert.Equal(t, 4, entry.Priority)
+	assert.True(t, entry.Accelerate)
+	assert.False(t, pendingEntry(query, node, 2).Accelerate)
+}
+
+// Active queries and nodes to compare evaluating targets in each check-in with reading materialized entries
+const (
+	benchActiveQueries = 500
+	benchNodes         = 20000
+)
+
+func benchTargets() ([]nodes.OsqueryNode, map[string][]DistributedQueryTarget) {
+	nodesList := make([]nodes.OsqueryNode, benchNodes)
+	for i := range nodesList {
+		nodesList[i] = nodes.OsqueryNode{UUID: fmt.Sprintf("uuid-%d", i), Localname: fmt.Sprintf("host-%d", i), Platform: "ubuntu", Environment: "dev"}
+		nodesList[i].ID = uint(i + 1)
+	}
+	targets := make(map[string][]DistributedQueryTarget, benchActiveQueries)
+	for i := 0; i < benchActiveQueries; i++ {
+		name := fmt.Sprintf("query-%d", i)
+		for j := 0; j < 10; j++ {
+			targets[name] = append(targets[name], DistributedQueryTarget{Type: QueryTargetUUID, Value: fmt.Sprintf("uuid-%d", (i*10+j)%benchNodes)})
+		}
+	}
+	return nodesList, targets
+}
+
+func BenchmarkCheckInEvaluateTargets(b *testing.B) {
+	nodesList, targets := benchTargets()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		node := nodesList[i%benchNodes]
+		for _, t := range targets {
+			_ = isQueryTarget(node, t)
+		}
+	}
+}
+
+func BenchmarkCheckInMaterialized(b *testing.B) {
+	nodesList, targets := benchTargets()
+	byUUID := make(map[string]nodes.OsqueryNode, len(nodesList))
+	for _, n := range nodesList {
+		byUUID[n.UUID] = n
+	}
+	byNode := make(map[uint][]NodeQuery)
+	for name, t := range targets {
+		query := DistributedQuery{Name: name}
+		for _, target := range t {
+			n := byUUID[target.Value]
+			byNode[n.ID] = append(byNode[n.ID], pendingEntry(query, n, len(t)))
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = byNode[nodesList[i%benchNodes].ID]
+	}
+}
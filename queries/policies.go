@@ -0,0 +1,249 @@
+package queries
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// PolicyAllow for policies that only allow the listed tables
+	PolicyAllow string = "allow"
+	// PolicyDeny for policies that deny the listed tables
+	PolicyDeny string = "deny"
+	// PolicyDefault is the subject of the policy for users and keys without their own policy
+	PolicyDefault string = "*"
+	// MaxPolicySubject is the maximum length of the subject of a policy
+	MaxPolicySubject = 255
+)
+
+// QueryPolicy to restrict the osquery tables and columns that a user or a service key can query in an environment.
+// Tables are a comma separated list of names, with * and ? wildcards, and columns are a comma separated list of
+// table.column patterns that are always denied. The subject is a username, a service key as apikey:name or * for
+// everyone else.
+type QueryPolicy struct {
+	gorm.Model
+	EnvironmentID uint   `gorm:"uniqueIndex:idx_query_policies_env_subject"`
+	Subject       string `gorm:"uniqueIndex:idx_query_policies_env_subject"`
+	Mode          string
+	Tables        string
+	Columns       string
+	UpdatedBy     string
+}
+
+// PolicyViolation is returned when a query uses tables or columns restricted by a policy
+type PolicyViolation struct {
+	Subject string
+	Tables  []string
+	Columns []string
+}
+
+// Error to name the restricted tables and columns of the violation
+func (v *PolicyViolation) Error() string {
+	var parts []string
+	if len(v.Tables) > 0 {
+		parts = append(parts, "restricted tables: "+strings.Join(v.Tables, ", "))
+	}
+	if len(v.Columns) > 0 {
+		parts = append(parts, "restricted columns: "+strings.Join(v.Columns, ", "))
+	}
+	return fmt.Sprintf("query not allowed by policy for %s, %s", v.Subject, strings.Join(parts, "; "))
+}
+
+// Helper to split a comma separated list of patterns, lowercase
+func policyList(list string) []string {
+	var values []string
+	for _, v := range strings.Split(list, ",") {
+		if v = strings.ToLower(strings.TrimSpace(v)); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// TableList to get the table patterns of a policy
+func (p QueryPolicy) TableList() []string {
+	return policyList(p.Tables)
+}
+
+// ColumnList to get the table.column patterns of a policy
+func (p QueryPolicy) ColumnList() []string {
+	return policyList(p.Columns)
+}
+
+// Helper to check if a name matches any of the patterns
+func policyMatch(name string, patterns []string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidatePolicy to check the subject, mode and patterns of a policy
+func ValidatePolicy(p QueryPolicy) error {
+	if strings.TrimSpace(p.Subject) == "" {
+		return fmt.Errorf("subject can not be empty")
+	}
+	if len(p.Subject) > MaxPolicySubject {
+		return fmt.Errorf("subject is longer than %d characters", MaxPolicySubject)
+	}
+	if p.Mode != PolicyAllow && p.Mode != PolicyDeny {
+		return fmt.Errorf("invalid mode %q, use %s or %s", p.Mode, PolicyAllow, PolicyDeny)
+	}
+	for _, t := range p.TableList() {
+		if _, err := path.Match(t, ""); err != nil {
+			return fmt.Errorf("invalid table pattern %q", t)
+		}
+	}
+	for _, c := range p.ColumnList() {
+		parts := strings.Split(c, ".")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("invalid column pattern %q, use table.column", c)
+		}
+		if _, err := path.Match(c, ""); err != nil {
+			return fmt.Errorf("invalid column pattern %q", c)
+		}
+	}
+	return nil
+}
+
+// Check to evaluate a query against the policy. Queries that can not be parsed or without tables are not allowed,
+// and columns of restricted table.column patterns are denied when they are referenced or when the query selects all
+// the columns.
+func (p QueryPolicy) Check(sql string) error {
+	refs, err := ParseSQLRefs(sql)
+	if err != nil {
+		return fmt.Errorf("query can not be checked against policy for %s - %v", p.Subject, err)
+	}
+	// Fail closed, a query where no table was found can not be checked against the policy
+	if len(refs.Tables) == 0 {
+		return fmt.Errorf("query can not be checked against policy for %s - no tables found", p.Subject)
+	}
+	v := &PolicyViolation{Subject: p.Subject}
+	tables := p.TableList()
+	for _, t := range refs.Tables {
+		matched := policyMatch(t, tables)
+		if (p.Mode == PolicyAllow && !matched) || (p.Mode == PolicyDeny && matched) {
+			v.Tables = append(v.Tables, t)
+		}
+	}
+	denied := make(map[string]bool)
+	for _, rule := range p.ColumnList() {
+		parts := strings.SplitN(rule, ".", 2)
+		for _, t := range refs.Tables {
+			if ok, _ := path.Match(parts[0], t); !ok {
+				continue
+			}
+			if refs.Star {
+				denied[t+".*"] = true
+			}
+			for _, c := range refs.Columns {
+				if ok, _ := path.Match(parts[1], c); ok {
+					denied[t+"."+c] = true
+				}
+			}
+		}
+	}
+	for c := range denied {
+		v.Columns = append(v.Columns, c)
+	}
+	sort.Strings(v.Columns)
+	if len(v.Tables) > 0 || len(v.Columns) > 0 {
+		return v
+	}
+	return nil
+}
+
+// GetPolicies to get the query policies of an environment
+func (q *Queries) GetPolicies(envid uint) ([]QueryPolicy, error) {
+	var policies []QueryPolicy
+	if err := q.DB.Where("environment_id = ?", envid).Order("subject").Find(&policies).Error; err != nil {
+		return policies, fmt.Errorf("Find %v", err)
+	}
+	return policies, nil
+}
+
+// SetPolicy to create or replace the query policy of a subject in an environment
+func (q *Queries) SetPolicy(envid uint, p QueryPolicy, user string) (QueryPolicy, error) {
+	p.Subject = strings.TrimSpace(p.Subject)
+	if err := ValidatePolicy(p); err != nil {
+		return p, err
+	}
+	p.Tables = strings.Join(p.TableList(), ",")
+	p.Columns = strings.Join(p.ColumnList(), ",")
+	var current QueryPolicy
+	if err := q.DB.Where("environment_id = ? AND subject = ?", envid, p.Subject).Find(&current).Error; err != nil {
+		return p, fmt.Errorf("Find %v", err)
+	}
+	if current.ID == 0 {
+		p = QueryPolicy{EnvironmentID: envid, Subject: p.Subject, Mode: p.Mode, Tables: p.Tables, Columns: p.Columns, UpdatedBy: user}
+		if err := q.DB.Create(&p).Error; err != nil {
+			return p, fmt.Errorf("Create %v", err)
+		}
+		return p, nil
+	}
+	if err := q.DB.Model(&current).Updates(map[string]interface{}{
+		"mode":       p.Mode,
+		"tables":     p.Tables,
+		"columns":    p.Columns,
+		"updated_by": user,
+	}).Error; err != nil {
+		return p, fmt.Errorf("Updates %v", err)
+	}
+	current.Mode = p.Mode
+	current.Tables = p.Tables
+	current.Columns = p.Columns
+	current.UpdatedBy = user
+	return current, nil
+}
+
+// DeletePolicy to delete the query policy of a subject in an environment
+func (q *Queries) DeletePolicy(envid uint, subject string) error {
+	tx := q.DB.Unscoped().Where("environment_id = ? AND subject = ?", envid, subject).Delete(&QueryPolicy{})
+	if tx.Error != nil {
+		return fmt.Errorf("Delete %v", tx.Error)
+	}
+	if tx.RowsAffected == 0 {
+		return fmt.Errorf("policy for %s not found", subject)
+	}
+	return nil
+}
+
+// PolicyFor to get the query policy that applies to a subject in an environment, its own or the default one
+func (q *Queries) PolicyFor(envid uint, subject string) (QueryPolicy, bool, error) {
+	var policies []QueryPolicy
+	if err := q.DB.Where("environment_id = ? AND subject IN ?", envid, []string{subject, PolicyDefault}).Find(&policies).Error; err != nil {
+		return QueryPolicy{}, false, fmt.Errorf("Find %v", err)
+	}
+	var found *QueryPolicy
+	for i := range policies {
+		if policies[i].Subject == subject || found == nil {
+			found = &policies[i]
+		}
+	}
+	if found == nil {
+		return QueryPolicy{}, false, nil
+	}
+	return *found, true, nil
+}
+
+// CheckPolicy to evaluate the SQL of a new query against the policy of its creator. Queries from templates
+// approved by admins are allowed even if they use restricted tables.
+func (q *Queries) CheckPolicy(envid uint, subject, sql string, tpl *QueryTemplate) error {
+	if tpl != nil && tpl.Approved {
+		return nil
+	}
+	p, ok, err := q.PolicyFor(envid, subject)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	return p.Check(sql)
+}
@@ -0,0 +1,62 @@
+package queries
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidatePolicy(t *testing.T) {
+	assert.NoError(t, ValidatePolicy(QueryPolicy{Subject: PolicyDefault, Mode: PolicyDeny, Tables: "shell_history, *_history", Columns: "users.shell"}))
+	assert.Error(t, ValidatePolicy(QueryPolicy{Subject: "", Mode: PolicyDeny}))
+	assert.Error(t, ValidatePolicy(QueryPolicy{Subject: "alice", Mode: "block"}))
+	assert.Error(t, ValidatePolicy(QueryPolicy{Subject: "alice", Mode: PolicyDeny, Tables: "[shell"}))
+	assert.Error(t, ValidatePolicy(QueryPolicy{Subject: "alice", Mode: PolicyDeny, Columns: "shell"}))
+}
+
+func TestPolicyCheckDeny(t *testing.T) {
+	p := QueryPolicy{Subject: PolicyDefault, Mode: PolicyDeny, Tables: "shell_history, chrome_*"}
+	assert.NoError(t, p.Check("SELECT * FROM processes;"))
+	err := p.Check("SELECT * FROM (SELECT * FROM shell_history)")
+	assert.Error(t, err)
+	v, ok := err.(*PolicyViolation)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"shell_history"}, v.Tables)
+	assert.Contains(t, err.Error(), "shell_history")
+	err = p.Check("SELECT * FROM users u JOIN chrome_extensions c USING (uid);")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "chrome_extensions")
+	// Queries that can not be parsed are not allowed
+	assert.Error(t, p.Check("SELECT * FROM (SELECT * FROM processes"))
+}
+
+func TestPolicyCheckAllow(t *testing.T) {
+	p := QueryPolicy{Subject: "alice", Mode: PolicyAllow, Tables: "processes, users"}
+	assert.NoError(t, p.Check("SELECT * FROM processes p JOIN users u ON p.uid = u.uid;"))
+	// Queries without tables can not be checked
+	assert.Error(t, p.Check("SELECT 1;"))
+	assert.Error(t, p.Check("SELECT * FROM (shell_history)"))
+	err := p.Check("WITH x AS (SELECT * FROM shell_history) SELECT * FROM processes, x;")
+	assert.Error(t, err)
+	assert.Equal(t, []string{"shell_history"}, err.(*PolicyViolation).Tables)
+}
+
+func TestPolicyCheckColumns(t *testing.T) {
+	p := QueryPolicy{Subject: PolicyDefault, Mode: PolicyDeny, Columns: "users.shell, processes.cmd*"}
+	assert.NoError(t, p.Check("SELECT username, uid FROM users;"))
+	err := p.Check("SELECT username, shell FROM users;")
+	assert.Error(t, err)
+	assert.Equal(t, []string{"users.shell"}, err.(*PolicyViolation).Columns)
+	err = p.Check("SELECT * FROM users;")
+	assert.Error(t, err)
+	assert.Equal(t, []string{"users.*"}, err.(*PolicyViolation).Columns)
+	err = p.Check("SELECT pid, cmdline FROM processes;")
+	assert.Error(t, err)
+	assert.Equal(t, []string{"processes.cmdline"}, err.(*PolicyViolation).Columns)
+}
+
+func TestPolicyList(t *testing.T) {
+	p := QueryPolicy{Tables: " Shell_History, ,chrome_* "}
+	assert.Equal(t, []string{"shell_history", "chrome_*"}, p.TableList())
+	assert.Empty(t, QueryPolicy{}.ColumnList())
+}
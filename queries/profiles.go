@@ -0,0 +1,297 @@
+package queries
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// DefaultProfileSample is the default number of nodes to run a profile query
+	DefaultProfileSample = 10
+	// Column with the number of rows returned by a profile query
+	profileRowsColumn = "row_count"
+	// Unique index of the slots of the sample, so it never takes more nodes than its size
+	profileSlotsIndex = "idx_profile_sample_slot"
+	// Attempts to take a slot of a sample when other nodes take the same one
+	profileSampleRetries = 3
+	// Maximum number of cached query names known to be profile queries or not
+	maxProfileNames = 10000
+)
+
+// Conditional insert of a node in the next slot of a sample, only while the sample has room
+const profileSampleInsert = `INSERT INTO query_profile_samples (created_at, updated_at, name, uuid, slot)
+SELECT ?, ?, ?, ?, COALESCE(MAX(slot) + 1, 0) FROM query_profile_samples WHERE name = ? HAVING COUNT(*) < ?
+ON CONFLICT DO NOTHING`
+
+// Insert of a random sample of the nodes with the query pending, each one in its own slot
+const profileSampleSelect = `INSERT INTO query_profile_samples (created_at, updated_at, name, uuid, slot)
+SELECT ?, ?, name, uuid, ROW_NUMBER() OVER () - 1 FROM (SELECT name, uuid FROM node_queries WHERE name = ? ORDER BY RANDOM() LIMIT ?) AS sampled
+ON CONFLICT DO NOTHING`
+
+// QueryProfileSample to keep the nodes selected to run a profile query
+type QueryProfileSample struct {
+	gorm.Model
+	Name string `gorm:"index;uniqueIndex:idx_profile_sample_slot;uniqueIndex:idx_profile_sample_node"`
+	UUID string `gorm:"uniqueIndex:idx_profile_sample_node"`
+	Slot int    `gorm:"uniqueIndex:idx_profile_sample_slot"`
+}
+
+// QueryProfileResult to keep the execution metadata of a profile query in one node
+type QueryProfileResult struct {
+	gorm.Model
+	Name       string `gorm:"index"`
+	UUID       string
+	Status     int
+	Rows       int64
+	WallTimeMs int64
+	UserTime   int64
+	SystemTime int64
+	Memory     int64
+}
+
+// ProfileStats to hold the execution stats reported by osquery for one query
+type ProfileStats struct {
+	WallTimeMs int64
+	UserTime   int64
+	SystemTime int64
+	Memory     int64
+}
+
+// ProfileReport to summarize the results of a profile query
+type ProfileReport struct {
+	Name          string               `json:"name"`
+	Query         string               `json:"query"`
+	SampleSize    int                  `json:"sample_size"`
+	Targeted      int                  `json:"targeted"`
+	Samples       int                  `json:"samples"`
+	Errors        int                  `json:"errors"`
+	WallTimeMin   int64                `json:"wall_time_min_ms"`
+	WallTimeP50   int64                `json:"wall_time_p50_ms"`
+	WallTimeP90   int64                `json:"wall_time_p90_ms"`
+	WallTimeMax   int64                `json:"wall_time_max_ms"`
+	MemoryMax     int64                `json:"memory_max"`
+	RowsTotal     int64                `json:"rows_total"`
+	RowsAvg       float64              `json:"rows_avg"`
+	ProjectedRows int64                `json:"projected_rows"`
+	Launched      string               `json:"launched"`
+	Results       []QueryProfileResult `json:"results"`
+}
+
+// ProfileWrap to wrap a query so nodes only return the number of rows
+func ProfileWrap(query string) string {
+	query = strings.TrimRight(strings.TrimSpace(query), ";")
+	return fmt.Sprintf("SELECT COUNT(*) AS %s FROM (%s);", profileRowsColumn, query)
+}
+
+// ParseProfileRows to extract the number of rows from the result of a profile query
+func ParseProfileRows(result json.RawMessage) int64 {
+	var rows []map[string]string
+	if err := json.Unmarshal(result, &rows); err != nil || len(rows) == 0 {
+		return 0
+	}
+	count, err := strconv.ParseInt(rows[0][profileRowsColumn], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return count
+}
+
+// SetProfileTargets to keep the number of nodes targeted by a profile query, capping expected to the sample.
+// The sample is a random selection of the nodes the query is pending for.
+func (q *Queries) SetProfileTargets(name string, targeted int, envid uint) error {
+	query, err := q.Get(name, envid)
+	if err != nil {
+		return err
+	}
+	expected := targeted
+	if query.SampleSize < expected {
+		expected = query.SampleSize
+	}
+	if err := q.DB.Model(&query).Updates(map[string]interface{}{"targeted": targeted, "expected": expected}).Error; err != nil {
+		return fmt.Errorf("Updates %v", err)
+	}
+	now := time.Now()
+	if err := q.DB.Exec(profileSampleSelect, now, now, name, query.SampleSize).Error; err != nil {
+		return fmt.Errorf("Exec %v", err)
+	}
+	return nil
+}
+
+// InProfileSample to check if a node runs a profile query, adding it to the sample if there is room
+func (q *Queries) InProfileSample(query DistributedQuery, uuid string) bool {
+	for i := 0; i < profileSampleRetries; i++ {
+		var sampled int64
+		q.DB.Model(&QueryProfileSample{}).Where("name = ? AND uuid = ?", query.Name, uuid).Count(&sampled)
+		if sampled > 0 {
+			return true
+		}
+		now := time.Now()
+		result := q.DB.Exec(profileSampleInsert, now, now, query.Name, uuid, query.Name, query.SampleSize)
+		if result.Error != nil {
+			return false
+		}
+		if result.RowsAffected == 1 {
+			return true
+		}
+		// Nothing was inserted, because the sample is full or another node took the same slot
+		q.DB.Model(&QueryProfileSample{}).Where("name = ?", query.Name).Count(&sampled)
+		if sampled >= int64(query.SampleSize) {
+			return false
+		}
+	}
+	return false
+}
+
+// IsProfile to check if a query is a profile query, cached since the type of a query does not change
+func (q *Queries) IsProfile(name string, envid uint) (bool, error) {
+	key := fmt.Sprintf("%d:%s", envid, name)
+	q.profilesMux.Lock()
+	profile, ok := q.profiles[key]
+	q.profilesMux.Unlock()
+	if ok {
+		return profile, nil
+	}
+	query, err := q.Get(name, envid)
+	if err != nil {
+		return false, err
+	}
+	// Queries not found are not cached, in case they are created later
+	if query.ID == 0 {
+		return false, nil
+	}
+	profile = query.Type == ProfileQueryType
+	q.profilesMux.Lock()
+	if q.profiles == nil || len(q.profiles) >= maxProfileNames {
+		q.profiles = make(map[string]bool)
+	}
+	q.profiles[key] = profile
+	q.profilesMux.Unlock()
+	return profile, nil
+}
+
+// Helper to clear samples taken before the unique index of slots, the nodes are sampled again when they check in
+func migrateProfileSamples(backend *gorm.DB) error {
+	m := backend.Migrator()
+	if !m.HasTable(&QueryProfileSample{}) || m.HasIndex(&QueryProfileSample{}, profileSlotsIndex) {
+		return nil
+	}
+	return backend.Session(&gorm.Session{AllowGlobalUpdate: true}).Unscoped().Delete(&QueryProfileSample{}).Error
+}
+
+// SaveProfileResult to keep the execution metadata of a profile query, discarding the result rows.
+// The result replaces any previous one of the same node.
+func (q *Queries) SaveProfileResult(name, uuid string, result json.RawMessage, status int, stats ProfileStats) error {
+	entry := QueryProfileResult{
+		Name:       name,
+		UUID:       uuid,
+		Status:     status,
+		Rows:       ParseProfileRows(result),
+		WallTimeMs: stats.WallTimeMs,
+		UserTime:   stats.UserTime,
+		SystemTime: stats.SystemTime,
+		Memory:     stats.Memory,
+	}
+	return q.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("name = ? AND uuid = ?", name, uuid).Delete(&QueryProfileResult{}).Error; err != nil {
+			return fmt.Errorf("Delete %v", err)
+		}
+		if err := tx.Create(&entry).Error; err != nil {
+			return fmt.Errorf("Create %v", err)
+		}
+		return nil
+	})
+}
+
+// GetProfileReport to summarize the results of a profile query
+func (q *Queries) GetProfileReport(name string, envid uint) (ProfileReport, error) {
+	var report ProfileReport
+	query, err := q.Get(name, envid)
+	if err != nil {
+		return report, err
+	}
+	if query.Type != ProfileQueryType {
+		return report, fmt.Errorf("%s is not a profile query", name)
+	}
+	report.Name = query.Name
+	report.Query = query.Query
+	report.SampleSize = query.SampleSize
+	report.Targeted = query.Targeted
+	if err := q.read().Where("name = ?", name).Order("wall_time_ms").Find(&report.Results).Error; err != nil {
+		return report, fmt.Errorf("Find %v", err)
+	}
+	var times []int64
+	for _, r := range report.Results {
+		if r.Status != 0 {
+			report.Errors++
+			continue
+		}
+		report.Samples++
+		report.RowsTotal += r.Rows
+		times = append(times, r.WallTimeMs)
+		if r.Memory > report.MemoryMax {
+			report.MemoryMax = r.Memory
+		}
+	}
+	if len(times) > 0 {
+		sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+		report.WallTimeMin = times[0]
+		report.WallTimeP50 = times[len(times)*50/100]
+		report.WallTimeP90 = times[len(times)*90/100]
+		report.WallTimeMax = times[len(times)-1]
+		report.RowsAvg = float64(report.RowsTotal) / float64(report.Samples)
+		report.ProjectedRows = int64(report.RowsAvg * float64(report.Targeted))
+	}
+	var launched DistributedQuery
+	if err := q.read().Where("profile_name = ?", name).Limit(1).Find(&launched).Error; err != nil {
+		return report, fmt.Errorf("Find %v", err)
+	}
+	report.Launched = launched.Name
+	return report, nil
+}
+
+// LaunchProfile to create the real query from a profile query, with the same targets
+func (q *Queries) LaunchProfile(name string, envid uint, creator string) (DistributedQuery, error) {
+	profile, err := q.Get(name, envid)
+	if err != nil {
+		return DistributedQuery{}, err
+	}
+	if profile.Type != ProfileQueryType {
+		return DistributedQuery{}, fmt.Errorf("%s is not a profile query", name)
+	}
+	targets, err := q.GetTargets(name)
+	if err != nil {
+		return DistributedQuery{}, err
+	}
+	newQuery := DistributedQuery{
+		Query:         profile.Query,
+		Name:          GenQueryName(),
+		Creator:       creator,
+		Expected:      profile.Targeted,
+		Active:        true,
+		Hidden:        profile.Hidden,
+		Type:          StandardQueryType,
+		EnvironmentID: profile.EnvironmentID,
+		ProfileName:   profile.Name,
+		// Results of the launched query are as restricted as the ones of the profile
+		Visibility:     profile.Visibility,
+		VisibilityTeam: profile.VisibilityTeam,
+	}
+	if err := q.Create(newQuery); err != nil {
+		return DistributedQuery{}, err
+	}
+	for _, t := range targets {
+		if err := q.CreateTarget(newQuery.Name, t.Type, t.Value); err != nil {
+			return newQuery, err
+		}
+	}
+	if _, err := q.Materialize(newQuery.Name, newQuery.EnvironmentID); err != nil {
+		return newQuery, err
+	}
+	return newQuery, nil
+}
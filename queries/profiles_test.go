@@ -0,0 +1,100 @@
+package queries
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+const (
+	profileSampledCount = `SELECT count\(\*\) FROM "query_profile_samples" WHERE \(name = \$1 AND uuid = \$2\)`
+	profileSampleCount  = `SELECT count\(\*\) FROM "query_profile_samples" WHERE name = \$1`
+	profileSlotInsert   = `INSERT INTO query_profile_samples .* COALESCE\(MAX\(slot\) \+ 1, 0\) .* HAVING COUNT\(\*\) < \$6 ON CONFLICT DO NOTHING`
+)
+
+// Helper to get queries with a mocked postgres DB
+func mockQueries(t *testing.T) (*Queries, sqlmock.Sqlmock) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db, got error: %v", err)
+	}
+	t.Cleanup(func() { mockDB.Close() })
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: mockDB}), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("unable to create new postgres database: %v", err)
+	}
+	return &Queries{DB: db}, mock
+}
+
+func TestInProfileSampleInserted(t *testing.T) {
+	q, mock := mockQueries(t)
+	mock.ExpectQuery(profileSampledCount).WithArgs("profile_1", "uuid_1").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectExec(profileSlotInsert).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), "profile_1", "uuid_1", "profile_1", 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.True(t, q.InProfileSample(DistributedQuery{Name: "profile_1", SampleSize: 2}, "uuid_1"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestInProfileSampleSampled(t *testing.T) {
+	q, mock := mockQueries(t)
+	mock.ExpectQuery(profileSampledCount).WithArgs("profile_1", "uuid_1").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	assert.True(t, q.InProfileSample(DistributedQuery{Name: "profile_1", SampleSize: 2}, "uuid_1"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestInProfileSampleFull(t *testing.T) {
+	q, mock := mockQueries(t)
+	mock.ExpectQuery(profileSampledCount).WithArgs("profile_1", "uuid_3").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectExec(profileSlotInsert).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(profileSampleCount).WithArgs("profile_1").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	assert.False(t, q.InProfileSample(DistributedQuery{Name: "profile_1", SampleSize: 2}, "uuid_3"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestInProfileSampleSlotTaken(t *testing.T) {
+	q, mock := mockQueries(t)
+	// Another node takes the same slot, the node takes the next one while there is room
+	mock.ExpectQuery(profileSampledCount).WithArgs("profile_1", "uuid_2").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectExec(profileSlotInsert).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(profileSampleCount).WithArgs("profile_1").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(profileSampledCount).WithArgs("profile_1", "uuid_2").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectExec(profileSlotInsert).WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.True(t, q.InProfileSample(DistributedQuery{Name: "profile_1", SampleSize: 2}, "uuid_2"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSetProfileTargetsRandomSample(t *testing.T) {
+	q, mock := mockQueries(t)
+	mock.ExpectQuery(`SELECT \* FROM "distributed_queries" WHERE \(name = \$1 AND environment_id = \$2\)`).
+		WithArgs("profile_1", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "environment_id", "type", "sample_size"}).AddRow(3, "profile_1", 1, ProfileQueryType, 10))
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "distributed_queries" SET "expected"=\$1,"targeted"=\$2,"updated_at"=\$3`).
+		WithArgs(10, 50, sqlmock.AnyArg(), 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	// The sample is selected at random from the nodes with the query pending
+	mock.ExpectExec(`INSERT INTO query_profile_samples .* FROM \(SELECT name, uuid FROM node_queries WHERE name = \$3 ORDER BY RANDOM\(\) LIMIT \$4\) AS sampled ON CONFLICT DO NOTHING`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), "profile_1", 10).
+		WillReturnResult(sqlmock.NewResult(0, 10))
+	assert.NoError(t, q.SetProfileTargets("profile_1", 50, 1))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestIsProfileCached(t *testing.T) {
+	q, mock := mockQueries(t)
+	mock.ExpectQuery(`SELECT \* FROM "distributed_queries" WHERE \(name = \$1 AND environment_id = \$2\)`).
+		WithArgs("profile_1", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "environment_id", "type"}).AddRow(3, "profile_1", 1, ProfileQueryType))
+	// Only the first lookup reads the query
+	for i := 0; i < 3; i++ {
+		profile, err := q.IsProfile("profile_1", 1)
+		assert.NoError(t, err)
+		assert.True(t, profile)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -1,8 +1,13 @@
 package queries
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"sync"
+	"time"
 
+	"github.com/jmpsec/osctrl/backend"
 	"github.com/jmpsec/osctrl/nodes"
 	"gorm.io/gorm"
 )
@@ -22,6 +27,8 @@ const (
 	CarveQueryType string = "carve"
 	// MetadataQueryType defines a regular query
 	MetadataQueryType string = "metadata"
+	// ProfileQueryType defines a query that only collects execution metadata
+	ProfileQueryType string = "profile"
 )
 
 const (
@@ -55,21 +62,43 @@ const (
 // DistributedQuery as abstraction of a distributed query
 type DistributedQuery struct {
 	gorm.Model
-	Name          string `gorm:"not null;unique;index"`
-	Creator       string
-	Query         string
-	Expected      int
-	Executions    int
-	Errors        int
-	Active        bool
-	Hidden        bool
-	Protected     bool
-	Completed     bool
-	Deleted       bool
-	Type          string
-	Path          string
-	EnvironmentID uint
-	ExtraData     string
+	Name            string `gorm:"not null;unique;index"`
+	Creator         string
+	Query           string
+	Expected        int
+	Executions      int
+	Errors          int
+	Active          bool
+	Hidden          bool
+	Protected       bool
+	Completed       bool
+	Deleted         bool
+	DeletedBy       string
+	Type            string
+	Path            string
+	EnvironmentID   uint
+	ExtraData       string
+	SampleSize      int
+	Targeted        int
+	ProfileName     string
+	TemplateName    string
+	TemplateVersion int
+	Description     string
+	TicketID        string `gorm:"index"`
+	TicketURL       string
+	CaseID          string `gorm:"index"`
+	WindowMatched   int
+	WindowEvaluated time.Time
+	Group           string `gorm:"column:query_group;index"`
+	Priority        int
+	ExpiresAt       time.Time `gorm:"index"`
+	Visibility      string    `gorm:"index"`
+	VisibilityTeam  string
+	Archived        bool `gorm:"index"`
+	ArchiveID       uint
+	Labels          map[string]string `gorm:"-"`
+	Locked          bool              `gorm:"-"`
+	Paused          []string          `gorm:"-"`
 }
 
 // DistributedQueryTarget to keep target logic for queries
@@ -86,6 +115,7 @@ type DistributedQueryExecution struct {
 	Name   string `gorm:"index"`
 	UUID   string `gorm:"index"`
 	Result int
+	Hash   string
 }
 
 // QueryReadQueries to hold all the on-demand queries
@@ -93,7 +123,13 @@ type QueryReadQueries map[string]string
 
 // Queries to handle on-demand queries
 type Queries struct {
-	DB *gorm.DB
+	DB         *gorm.DB
+	Reader     func() *gorm.DB
+	executions [executionLocks]sync.Mutex
+	// OnComplete is called with every query once it is completed, if set
+	OnComplete  func(DistributedQuery)
+	profiles    map[string]bool
+	profilesMux sync.Mutex
 }
 
 // CreateQueries to initialize the queries struct
@@ -112,46 +148,153 @@ func CreateQueries(backend *gorm.DB) *Queries {
 	if err := backend.AutoMigrate(&DistributedQueryTarget{}); err != nil {
 		log.Fatalf("Failed to AutoMigrate table (distributed_query_targets): %v", err)
 	}
+	// table distributed_query_labels
+	if err := backend.AutoMigrate(&DistributedQueryLabel{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (distributed_query_labels): %v", err)
+	}
 	// table saved_queries
 	if err := backend.AutoMigrate(&SavedQuery{}); err != nil {
 		log.Fatalf("Failed to AutoMigrate table (saved_queries): %v", err)
 	}
+	// table query_profile_samples
+	if err := migrateProfileSamples(backend); err != nil {
+		log.Fatalf("Failed to migrate table (query_profile_samples): %v", err)
+	}
+	if err := backend.AutoMigrate(&QueryProfileSample{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (query_profile_samples): %v", err)
+	}
+	// table query_profile_results
+	if err := backend.AutoMigrate(&QueryProfileResult{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (query_profile_results): %v", err)
+	}
+	// table query_templates
+	if err := backend.AutoMigrate(&QueryTemplate{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (query_templates): %v", err)
+	}
+	// table query_template_versions
+	if err := backend.AutoMigrate(&QueryTemplateVersion{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (query_template_versions): %v", err)
+	}
+	// table recurring_carves
+	if err := backend.AutoMigrate(&RecurringCarve{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (recurring_carves): %v", err)
+	}
+	// table recurring_carve_runs
+	if err := backend.AutoMigrate(&RecurringCarveRun{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (recurring_carve_runs): %v", err)
+	}
+	// table share_exports
+	if err := backend.AutoMigrate(&ShareExport{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (share_exports): %v", err)
+	}
+	// table export_jobs
+	if err := backend.AutoMigrate(&ExportJob{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (export_jobs): %v", err)
+	}
+	// table query_archives
+	if err := backend.AutoMigrate(&QueryArchive{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (query_archives): %v", err)
+	}
+	// table query_groups
+	if err := backend.AutoMigrate(&QueryGroup{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (query_groups): %v", err)
+	}
+	// table query_policies
+	if err := backend.AutoMigrate(&QueryPolicy{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (query_policies): %v", err)
+	}
+	// table query_access_grants
+	if err := backend.AutoMigrate(&QueryAccessGrant{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (query_access_grants): %v", err)
+	}
+	// table quick_actions
+	if err := backend.AutoMigrate(&QuickAction{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (quick_actions): %v", err)
+	}
+	// table quick_action_versions
+	if err := backend.AutoMigrate(&QuickActionVersion{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (quick_action_versions): %v", err)
+	}
+	// table node_queries, new tables get the pending entries of the queries already in flight
+	migrate := !backend.Migrator().HasTable(&NodeQuery{})
+	if err := backend.AutoMigrate(&NodeQuery{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (node_queries): %v", err)
+	}
+	// Queries deleted before the trash existed are moved to it, so they can be restored within the window
+	if err := backend.Model(&DistributedQuery{}).Where("deleted = ?", true).Update("deleted_at", time.Now()).Error; err != nil {
+		log.Printf("Failed to move deleted queries to the trash: %v", err)
+	}
+	if migrate {
+		if materialized, err := q.MaterializeActive(); err != nil {
+			log.Printf("Failed to materialize active queries: %v", err)
+		} else {
+			log.Printf("Materialized %d pending queries for nodes", materialized)
+		}
+	}
 	return q
 }
 
+// Helper to get the connection for reads that tolerate replication lag, DB if there is no Reader
+func (q *Queries) read() *gorm.DB {
+	if q.Reader != nil {
+		return q.Reader()
+	}
+	return q.DB
+}
+
 // NodeQueries to get all queries that belong to the provided node
-// FIXME this will impact the performance of the TLS endpoint due to being CPU and I/O hungry
-// FIMXE potential mitigation can be add a cache (Redis?) layer to store queries per node_key
 func (q *Queries) NodeQueries(node nodes.OsqueryNode) (QueryReadQueries, bool, error) {
+	return q.NodeQueriesCtx(context.Background(), node)
+}
+
+// NodeQueriesCtx to get all the on-demand queries pending for a node, aborting if ctx is cancelled.
+// The node is accelerated if any of them targets only one value.
+func (q *Queries) NodeQueriesCtx(ctx context.Context, node nodes.OsqueryNode) (QueryReadQueries, bool, error) {
 	acelerate := false
-	// Get all current active queries and carves
-	queries, err := q.GetActive(node.EnvironmentID)
+	pending, err := q.NodePending(ctx, node.ID)
 	if err != nil {
 		return QueryReadQueries{}, false, err
 	}
-	// Iterate through active queries, see if they target this node and prepare data in the same loop
-	qs := make(QueryReadQueries)
-	for _, _q := range queries {
-		targets, err := q.GetTargets(_q.Name)
-		if err != nil {
+	// Grouped queries are throttled by the limits of their group, ungrouped ones are always handed
+	if hasGroups(pending) {
+		if pending, err = q.dispatchGroups(ctx, node.EnvironmentID, pending); err != nil {
 			return QueryReadQueries{}, false, err
 		}
-		if len(targets) == 1 {
+	}
+	qs := make(QueryReadQueries)
+	for _, p := range pending {
+		if p.Accelerate {
 			acelerate = true
 		}
-		if isQueryTarget(node, targets) && q.NotYetExecuted(_q.Name, node.UUID) {
-			qs[_q.Name] = _q.Query
+		if p.Type == ProfileQueryType {
+			// Profile queries run only in a sample of nodes and return only the number of rows
+			query := DistributedQuery{Name: p.Name, Query: p.Query, SampleSize: p.SampleSize}
+			if q.InProfileSample(query, node.UUID) {
+				qs[p.Name] = ProfileWrap(p.Query)
+			}
+			continue
 		}
+		qs[p.Name] = p.Query
 	}
 	return qs, acelerate, nil
 }
 
 // Gets all queries by target (active/completed/all/all-full/deleted/hidden)
 func (q *Queries) Gets(target, qtype string, envid uint) ([]DistributedQuery, error) {
+	return q.GetsCtx(context.Background(), target, qtype, envid)
+}
+
+// GetsCtx all queries by target (active/completed/all/all-full/deleted/hidden), aborting if ctx is cancelled
+func (q *Queries) GetsCtx(ctx context.Context, target, qtype string, envid uint) ([]DistributedQuery, error) {
+	return q.getsWith(q.read().WithContext(ctx), target, qtype, envid)
+}
+
+// Helper to get all queries by target (active/completed/all/all-full/deleted/hidden) with the DB query db
+func (q *Queries) getsWith(db *gorm.DB, target, qtype string, envid uint) ([]DistributedQuery, error) {
 	var queries []DistributedQuery
 	switch target {
 	case TargetActive:
-		if err := q.DB.Where(
+		if err := db.Where(
 			"active = ? AND completed = ? AND deleted = ? AND type = ? AND environment_id = ?",
 			true,
 			false,
@@ -162,7 +305,7 @@ func (q *Queries) Gets(target, qtype string, envid uint) ([]DistributedQuery, er
 			return queries, err
 		}
 	case TargetCompleted:
-		if err := q.DB.Where(
+		if err := db.Where(
 			"active = ? AND completed = ? AND deleted = ? AND type = ? AND environment_id = ?",
 			false,
 			true,
@@ -173,7 +316,7 @@ func (q *Queries) Gets(target, qtype string, envid uint) ([]DistributedQuery, er
 			return queries, err
 		}
 	case TargetHiddenCompleted:
-		if err := q.DB.Where(
+		if err := db.Where(
 			"active = ? AND completed = ? AND deleted = ? AND hidden = ? AND type = ? AND environment_id = ?",
 			false,
 			true,
@@ -185,7 +328,7 @@ func (q *Queries) Gets(target, qtype string, envid uint) ([]DistributedQuery, er
 			return queries, err
 		}
 	case TargetAllFull:
-		if err := q.DB.Where(
+		if err := db.Where(
 			"deleted = ? AND type = ? AND environment_id = ?",
 			false,
 			qtype, envid,
@@ -193,7 +336,7 @@ func (q *Queries) Gets(target, qtype string, envid uint) ([]DistributedQuery, er
 			return queries, err
 		}
 	case TargetAll:
-		if err := q.DB.Where(
+		if err := db.Where(
 			"deleted = ? AND hidden = ? AND type = ? AND environment_id = ?",
 			false,
 			false,
@@ -203,15 +346,14 @@ func (q *Queries) Gets(target, qtype string, envid uint) ([]DistributedQuery, er
 			return queries, err
 		}
 	case TargetDeleted:
-		if err := q.DB.Where("deleted = ? AND type = ? AND environment_id = ?",
-			true,
+		if err := db.Unscoped().Where("deleted_at IS NOT NULL AND type = ? AND environment_id = ?",
 			qtype,
 			envid,
 		).Find(&queries).Error; err != nil {
 			return queries, err
 		}
 	case TargetHidden:
-		if err := q.DB.Where(
+		if err := db.Where(
 			"deleted = ? AND hidden = ? AND type = ? AND environment_id = ?",
 			false,
 			true,
@@ -226,8 +368,13 @@ func (q *Queries) Gets(target, qtype string, envid uint) ([]DistributedQuery, er
 
 // GetActive all active queries and carves by target
 func (q *Queries) GetActive(envid uint) ([]DistributedQuery, error) {
+	return q.GetActiveCtx(context.Background(), envid)
+}
+
+// GetActiveCtx all active queries and carves by target, aborting if ctx is cancelled
+func (q *Queries) GetActiveCtx(ctx context.Context, envid uint) ([]DistributedQuery, error) {
 	var queries []DistributedQuery
-	if err := q.DB.Where("active = ? AND environment_id = ?", true, envid).Find(&queries).Error; err != nil {
+	if err := q.read().WithContext(ctx).Where("active = ? AND environment_id = ?", true, envid).Find(&queries).Error; err != nil {
 		return queries, err
 	}
 	return queries, nil
@@ -238,15 +385,30 @@ func (q *Queries) GetQueries(target string, envid uint) ([]DistributedQuery, err
 	return q.Gets(target, StandardQueryType, envid)
 }
 
+// GetQueriesCtx all queries by target (active/completed/all/all-full/deleted/hidden), aborting if ctx is cancelled
+func (q *Queries) GetQueriesCtx(ctx context.Context, target string, envid uint) ([]DistributedQuery, error) {
+	return q.GetsCtx(ctx, target, StandardQueryType, envid)
+}
+
 // GetCarves all carve queries by target (active/completed/all/all-full/deleted/hidden)
 func (q *Queries) GetCarves(target string, envid uint) ([]DistributedQuery, error) {
 	return q.Gets(target, CarveQueryType, envid)
 }
 
+// GetCarvesCtx all carve queries by target (active/completed/all/all-full/deleted/hidden), aborting if ctx is cancelled
+func (q *Queries) GetCarvesCtx(ctx context.Context, target string, envid uint) ([]DistributedQuery, error) {
+	return q.GetsCtx(ctx, target, CarveQueryType, envid)
+}
+
 // Get to get a query by name
 func (q *Queries) Get(name string, envid uint) (DistributedQuery, error) {
+	return q.GetCtx(context.Background(), name, envid)
+}
+
+// GetCtx to get a query by name, aborting if ctx is cancelled
+func (q *Queries) GetCtx(ctx context.Context, name string, envid uint) (DistributedQuery, error) {
 	var query DistributedQuery
-	if err := q.DB.Where("name = ? AND environment_id = ?", name, envid).Find(&query).Error; err != nil {
+	if err := q.DB.WithContext(ctx).Where("name = ? AND environment_id = ?", name, envid).Find(&query).Error; err != nil {
 		return query, err
 	}
 	return query, nil
@@ -258,9 +420,24 @@ func (q *Queries) Complete(name string, envid uint) error {
 	if err != nil {
 		return err
 	}
-	if err := q.DB.Model(&query).Updates(map[string]interface{}{"completed": true, "active": false}).Error; err != nil {
+	return q.complete(query)
+}
+
+// Helper to mark a query as completed and clear its pending entries. The update only changes queries not completed,
+// so when requests complete the same query concurrently it is notified once.
+func (q *Queries) complete(query DistributedQuery) error {
+	tx := q.DB.Model(&DistributedQuery{}).Where("id = ? AND completed = ?", query.ID, false).
+		Updates(map[string]interface{}{"completed": true, "active": false})
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if err := q.ClearPending(query.Name); err != nil {
 		return err
 	}
+	if tx.RowsAffected == 1 && q.OnComplete != nil {
+		query.Completed, query.Active = true, false
+		q.OnComplete(query)
+	}
 	return nil
 }
 
@@ -271,9 +448,7 @@ func (q *Queries) VerifyComplete(name string, envid uint) error {
 		return err
 	}
 	if (query.Executions + query.Errors) >= query.Expected {
-		if err := q.DB.Model(&query).Updates(map[string]interface{}{"completed": true, "active": false}).Error; err != nil {
-			return err
-		}
+		return q.complete(query)
 	}
 	return nil
 }
@@ -284,22 +459,34 @@ func (q *Queries) Activate(name string, envid uint) error {
 	if err != nil {
 		return err
 	}
-	if err := q.DB.Model(&query).Updates(map[string]interface{}{"completed": false, "active": true}).Error; err != nil {
+	updates := map[string]interface{}{"completed": false, "active": true}
+	// Expired queries get their distribution window again
+	if now := time.Now(); query.Expired(now) {
+		updates["expires_at"] = now.Add(query.ExpiresAt.Sub(query.CreatedAt))
+	}
+	if err := q.DB.Model(&query).Updates(updates).Error; err != nil {
 		return err
 	}
-	return nil
+	_, err = q.Materialize(name, envid)
+	return err
 }
 
-// Delete to mark query as deleted
-func (q *Queries) Delete(name string, envid uint) error {
+// Delete to move a query to the trash, it stops being served to nodes and can be restored within the window
+func (q *Queries) Delete(name string, envid uint, user string) error {
 	query, err := q.Get(name, envid)
 	if err != nil {
 		return err
 	}
+	if query.ID == 0 {
+		return fmt.Errorf("query %s not found", name)
+	}
 	if err := q.DB.Model(&query).Updates(map[string]interface{}{"deleted": true, "active": false}).Error; err != nil {
 		return err
 	}
-	return nil
+	if err := backend.SoftDelete(q.DB, &query, user); err != nil {
+		return err
+	}
+	return q.ClearPending(name)
 }
 
 // Create to create new query to be served to nodes
@@ -325,8 +512,13 @@ func (q *Queries) CreateTarget(name, targetType, targetValue string) error {
 
 // GetTargets to retrieve targets for a given query
 func (q *Queries) GetTargets(name string) ([]DistributedQueryTarget, error) {
+	return q.GetTargetsCtx(context.Background(), name)
+}
+
+// GetTargetsCtx to retrieve targets for a given query, aborting if ctx is cancelled
+func (q *Queries) GetTargetsCtx(ctx context.Context, name string) ([]DistributedQueryTarget, error) {
 	var targets []DistributedQueryTarget
-	if err := q.DB.Where("name = ?", name).Find(&targets).Error; err != nil {
+	if err := q.read().WithContext(ctx).Where("name = ?", name).Find(&targets).Error; err != nil {
 		return targets, err
 	}
 	return targets, nil
@@ -375,7 +567,7 @@ func (q *Queries) SetExpected(name string, expected int, envid uint) error {
 	return nil
 }
 
-// TrackExecution to keep track of where queries have already ran
+// TrackExecution to keep track of where queries have already ran, the query is no longer pending for the node
 func (q *Queries) TrackExecution(name, uuid string, result int) error {
 	queryExecution := DistributedQueryExecution{
 		Name:   name,
@@ -385,11 +577,31 @@ func (q *Queries) TrackExecution(name, uuid string, result int) error {
 	if err := q.DB.Create(&queryExecution).Error; err != nil {
 		return err
 	}
+	if err := q.DB.Where("name = ? AND uuid = ?", name, uuid).Delete(&NodeQuery{}).Error; err != nil {
+		return err
+	}
 	return nil
 }
 
 // Helper to decide whether if the query targets apply to a give node
 func isQueryTarget(node nodes.OsqueryNode, targets []DistributedQueryTarget) bool {
+	// Time windows restrict the nodes of the other targets, or select nodes on their own
+	window := WindowOfTargets(targets)
+	if !window.Empty() {
+		if !window.Matches(node) {
+			return false
+		}
+		selection := false
+		for _, t := range targets {
+			if !IsWindowTarget(t.Type) {
+				selection = true
+				break
+			}
+		}
+		if !selection {
+			return true
+		}
+	}
 	for _, t := range targets {
 		// Check for environment match
 		if t.Type == QueryTargetEnvironment && t.Value == node.Environment {
@@ -407,6 +619,8 @@ func isQueryTarget(node nodes.OsqueryNode, targets []DistributedQueryTarget) boo
 		if t.Type == QueryTargetLocalname && node.Localname == t.Value {
 			return true
 		}
+		// Attributes are set by external systems after nodes enroll, so they are only matched when
+		// queries are materialized
 	}
 	return false
 }
@@ -0,0 +1,63 @@
+package queries
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestCompleteConcurrent(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db, got error: %v", err)
+	}
+	defer mockDB.Close()
+	_postgres, err := gorm.Open(postgres.New(postgres.Config{Conn: mockDB}), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("unable to create new postgres database: %v", err)
+	}
+	mock.MatchExpectationsInOrder(false)
+	const requests = 4
+	for i := 0; i < requests; i++ {
+		mock.ExpectQuery(`SELECT \* FROM "distributed_queries" WHERE \(name = \$1 AND environment_id = \$2\)`).
+			WithArgs("query_1", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "environment_id", "active", "completed"}).AddRow(3, "query_1", 1, true, false))
+		mock.ExpectBegin()
+		// Only the first update finds the query not completed
+		affected := int64(0)
+		if i == 0 {
+			affected = 1
+		}
+		mock.ExpectExec(`UPDATE "distributed_queries" SET "active"=\$1,"completed"=\$2,"updated_at"=\$3 WHERE \(id = \$4 AND completed = \$5\)`).
+			WithArgs(false, true, sqlmock.AnyArg(), 3, false).
+			WillReturnResult(sqlmock.NewResult(0, affected))
+		mock.ExpectCommit()
+		mock.ExpectBegin()
+		mock.ExpectExec(`DELETE FROM "node_queries" WHERE name = \$1`).WithArgs("query_1").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+	}
+	var notified []DistributedQuery
+	var mux sync.Mutex
+	q := &Queries{DB: _postgres, OnComplete: func(query DistributedQuery) {
+		mux.Lock()
+		notified = append(notified, query)
+		mux.Unlock()
+	}}
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, q.Complete("query_1", 1))
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, notified, 1)
+	assert.True(t, notified[0].Completed)
+	assert.False(t, notified[0].Active)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
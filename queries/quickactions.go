@@ -0,0 +1,449 @@
+package queries
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// QuickActionQuery for quick actions that run a vetted query template with preset variables
+	QuickActionQuery string = "query"
+	// QuickActionCarve for quick actions that carve a preset path
+	QuickActionCarve string = "carve"
+	// QuickActionTag for quick actions that apply a tag
+	QuickActionTag string = "tag"
+	// QuickActionAccelerate for quick actions that accelerate the check-ins of nodes
+	QuickActionAccelerate string = "accelerate"
+)
+
+// QuickActionTypes to list all the valid types of quick actions
+var QuickActionTypes = []string{QuickActionQuery, QuickActionCarve, QuickActionTag, QuickActionAccelerate}
+
+const (
+	// DefaultQuickActionIcon for quick actions without icon
+	DefaultQuickActionIcon = "fas fa-bolt"
+	// MaxQuickActionTargets is how many nodes a quick action can run against at once
+	MaxQuickActionTargets = 100
+	// AccelerateQuery is the query sent to nodes to accelerate their check-ins
+	AccelerateQuery = "SELECT 1 AS accelerate;"
+)
+
+// Valid names and icons of quick actions
+var (
+	quickActionName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+	quickActionIcon = regexp.MustCompile(`^(fas|far|fab) fa-[a-z0-9-]+$`)
+)
+
+// QuickAction as abstraction of a button defined by admins for an environment, to run a preset action
+// against the selected nodes. Queries can only be run from vetted templates, never arbitrary SQL.
+type QuickAction struct {
+	ID            uint `gorm:"primarykey"`
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	EnvironmentID uint   `gorm:"uniqueIndex:idx_quick_actions_env_name"`
+	Name          string `gorm:"uniqueIndex:idx_quick_actions_env_name"`
+	Label         string
+	Icon          string
+	Type          string
+	Template      string
+	Variables     string
+	Path          string
+	Tag           string
+	Version       int
+	Creator       string
+	UpdatedBy     string
+}
+
+// QuickActionVersion to keep every version of a quick action, also when it is deleted
+type QuickActionVersion struct {
+	ID            uint `gorm:"primarykey"`
+	CreatedAt     time.Time
+	EnvironmentID uint   `gorm:"index"`
+	Name          string `gorm:"index"`
+	Version       int
+	Label         string
+	Icon          string
+	Type          string
+	Template      string
+	Variables     string
+	Path          string
+	Tag           string
+	Author        string
+	Change        string
+}
+
+// QuickActionPlan to describe exactly what a quick action runs, shown to confirm it before running it
+type QuickActionPlan struct {
+	Action          string   `json:"action"`
+	Label           string   `json:"label"`
+	Version         int      `json:"version"`
+	Type            string   `json:"type"`
+	Template        string   `json:"template,omitempty"`
+	TemplateVersion int      `json:"template_version,omitempty"`
+	Query           string   `json:"query,omitempty"`
+	Path            string   `json:"path,omitempty"`
+	Tag             string   `json:"tag,omitempty"`
+	Targets         []string `json:"targets"`
+	Summary         string   `json:"summary"`
+}
+
+// Vars to get the preset values of the variables of the template of a quick action
+func (a QuickAction) Vars() (map[string]string, error) {
+	vars := make(map[string]string)
+	if a.Variables == "" {
+		return vars, nil
+	}
+	if err := json.Unmarshal([]byte(a.Variables), &vars); err != nil {
+		return vars, fmt.Errorf("invalid variables %v", err)
+	}
+	return vars, nil
+}
+
+// IconClass to get the icon of a quick action, the default if none was set
+func (a QuickAction) IconClass() string {
+	if a.Icon == "" {
+		return DefaultQuickActionIcon
+	}
+	return a.Icon
+}
+
+// Definition to describe what a quick action runs, without targets
+func (a QuickAction) Definition() string {
+	switch a.Type {
+	case QuickActionQuery:
+		vars, _ := a.Vars()
+		return "template " + a.Template + " " + EncodeQuickActionVariables(vars)
+	case QuickActionCarve:
+		return "carve " + a.Path
+	case QuickActionTag:
+		return "tag " + a.Tag
+	}
+	return "accelerate check-ins"
+}
+
+// EncodeQuickActionVariables to encode preset values of variables as name=value, sorted by name
+func EncodeQuickActionVariables(vars map[string]string) string {
+	names := make([]string, 0, len(vars))
+	for n := range vars {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	pairs := make([]string, 0, len(names))
+	for _, n := range names {
+		pairs = append(pairs, n+"="+vars[n])
+	}
+	return strings.Join(pairs, ",")
+}
+
+// ParseQuickActionVariables to parse preset values of variables as name=value separated by commas
+func ParseQuickActionVariables(spec string) (map[string]string, error) {
+	vars := make(map[string]string)
+	for _, pair := range strings.Split(spec, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		name := strings.TrimSpace(kv[0])
+		if len(kv) != 2 || !templateVarName.MatchString(name) {
+			return vars, fmt.Errorf("invalid variable %s, expected name=value", pair)
+		}
+		vars[name] = strings.TrimSpace(kv[1])
+	}
+	return vars, nil
+}
+
+// ValidateQuickAction to check the definition of a quick action, each type uses only its own fields
+func ValidateQuickAction(a QuickAction) error {
+	if !quickActionName.MatchString(a.Name) {
+		return fmt.Errorf("invalid name %s, use lowercase letters, digits, - and _", a.Name)
+	}
+	if strings.TrimSpace(a.Label) == "" || len(a.Label) > 64 {
+		return fmt.Errorf("label must have between 1 and 64 characters")
+	}
+	if a.Icon != "" && !quickActionIcon.MatchString(a.Icon) {
+		return fmt.Errorf("invalid icon %s, like fas fa-bolt", a.Icon)
+	}
+	if _, err := a.Vars(); err != nil {
+		return err
+	}
+	switch a.Type {
+	case QuickActionQuery:
+		if a.Template == "" {
+			return fmt.Errorf("template is required for %s quick actions", a.Type)
+		}
+		if a.Path != "" || a.Tag != "" {
+			return fmt.Errorf("%s quick actions only use a template and its variables", a.Type)
+		}
+	case QuickActionCarve:
+		if strings.TrimSpace(a.Path) == "" {
+			return fmt.Errorf("path is required for %s quick actions", a.Type)
+		}
+		if a.Template != "" || a.Variables != "" || a.Tag != "" {
+			return fmt.Errorf("%s quick actions only use a path", a.Type)
+		}
+	case QuickActionTag:
+		if strings.TrimSpace(a.Tag) == "" {
+			return fmt.Errorf("tag is required for %s quick actions", a.Type)
+		}
+		if a.Template != "" || a.Variables != "" || a.Path != "" {
+			return fmt.Errorf("%s quick actions only use a tag", a.Type)
+		}
+	case QuickActionAccelerate:
+		if a.Template != "" || a.Variables != "" || a.Path != "" || a.Tag != "" {
+			return fmt.Errorf("%s quick actions do not use template, path or tag", a.Type)
+		}
+	default:
+		return fmt.Errorf("invalid type %s, must be one of %s", a.Type, strings.Join(QuickActionTypes, ", "))
+	}
+	return nil
+}
+
+// Helper to check that a quick action only references a vetted template and renders its query
+func vettedQuery(a QuickAction, t *QueryTemplate) (string, error) {
+	if t == nil || t.Name != a.Template {
+		return "", fmt.Errorf("template %s not found", a.Template)
+	}
+	if !t.Approved {
+		return "", fmt.Errorf("template %s is not approved", t.Name)
+	}
+	vars, err := a.Vars()
+	if err != nil {
+		return "", err
+	}
+	return RenderTemplate(*t, vars)
+}
+
+// PlanQuickAction to prepare what a quick action runs against the targeted nodes, the template is required
+// for query actions and must be approved
+func PlanQuickAction(a QuickAction, t *QueryTemplate, targets []string) (QuickActionPlan, error) {
+	plan := QuickActionPlan{Action: a.Name, Label: a.Label, Version: a.Version, Type: a.Type}
+	seen := make(map[string]bool)
+	for _, u := range targets {
+		if u = strings.TrimSpace(u); u != "" && !seen[u] {
+			seen[u] = true
+			plan.Targets = append(plan.Targets, u)
+		}
+	}
+	if len(plan.Targets) == 0 {
+		return plan, fmt.Errorf("no nodes selected")
+	}
+	if len(plan.Targets) > MaxQuickActionTargets {
+		return plan, fmt.Errorf("quick actions run against %d nodes at most", MaxQuickActionTargets)
+	}
+	nodes := fmt.Sprintf("%d node(s)", len(plan.Targets))
+	switch a.Type {
+	case QuickActionQuery:
+		query, err := vettedQuery(a, t)
+		if err != nil {
+			return plan, err
+		}
+		plan.Template = t.Name
+		plan.TemplateVersion = t.Version
+		plan.Query = query
+		plan.Summary = fmt.Sprintf("Run template %s (v%d) in %s: %s", t.Name, t.Version, nodes, query)
+	case QuickActionCarve:
+		plan.Path = a.Path
+		plan.Query = GenRecurringCarveQuery([]string{a.Path})
+		plan.Summary = fmt.Sprintf("Carve %s in %s", a.Path, nodes)
+	case QuickActionTag:
+		plan.Tag = a.Tag
+		plan.Summary = fmt.Sprintf("Tag %s with %s", nodes, a.Tag)
+	case QuickActionAccelerate:
+		plan.Query = AccelerateQuery
+		plan.Summary = fmt.Sprintf("Accelerate the check-ins of %s", nodes)
+	default:
+		return plan, fmt.Errorf("invalid type %s", a.Type)
+	}
+	return plan, nil
+}
+
+// QuickActionRunSummary to describe an invocation of a quick action, for the activity of environments
+func QuickActionRunSummary(plan QuickActionPlan, launched []string) string {
+	summary := fmt.Sprintf("quick action %s (v%d) run on %s", plan.Action, plan.Version, strings.Join(plan.Targets, ", "))
+	if len(launched) > 0 {
+		summary += " as " + strings.Join(launched, ", ")
+	}
+	return summary
+}
+
+// Helper to prepare a version entry of a quick action
+func quickActionVersion(a QuickAction, author, change string) QuickActionVersion {
+	return QuickActionVersion{
+		EnvironmentID: a.EnvironmentID,
+		Name:          a.Name,
+		Version:       a.Version,
+		Label:         a.Label,
+		Icon:          a.Icon,
+		Type:          a.Type,
+		Template:      a.Template,
+		Variables:     a.Variables,
+		Path:          a.Path,
+		Tag:           a.Tag,
+		Author:        author,
+		Change:        change,
+	}
+}
+
+// GetQuickActions to get all the quick actions of an environment
+func (q *Queries) GetQuickActions(envid uint) ([]QuickAction, error) {
+	var actions []QuickAction
+	if err := q.read().Where("environment_id = ?", envid).Order("name").Find(&actions).Error; err != nil {
+		return actions, err
+	}
+	return actions, nil
+}
+
+// GetQuickAction to get a quick action of an environment by name
+func (q *Queries) GetQuickAction(name string, envid uint) (QuickAction, error) {
+	var a QuickAction
+	if err := q.read().Where("name = ? AND environment_id = ?", name, envid).First(&a).Error; err != nil {
+		return a, err
+	}
+	return a, nil
+}
+
+// GetQuickActionVersions to get all the versions of a quick action by name, newest first
+func (q *Queries) GetQuickActionVersions(name string, envid uint) ([]QuickActionVersion, error) {
+	var versions []QuickActionVersion
+	if err := q.read().Where("name = ? AND environment_id = ?", name, envid).Order("version desc").Find(&versions).Error; err != nil {
+		return versions, err
+	}
+	return versions, nil
+}
+
+// SetQuickAction to create or update a quick action of an environment, as a new version. Query actions must
+// reference an approved template and preset all its variables.
+func (q *Queries) SetQuickAction(envid uint, a QuickAction, author string) (QuickAction, error) {
+	a.Name = strings.TrimSpace(a.Name)
+	a.Label = strings.TrimSpace(a.Label)
+	a.Path = strings.TrimSpace(a.Path)
+	a.Tag = strings.TrimSpace(a.Tag)
+	if err := ValidateQuickAction(a); err != nil {
+		return a, err
+	}
+	if a.Type == QuickActionQuery {
+		t, err := q.GetTemplate(a.Template)
+		if err != nil {
+			return a, fmt.Errorf("template %s not found", a.Template)
+		}
+		if _, err := vettedQuery(a, &t); err != nil {
+			return a, err
+		}
+	}
+	a.EnvironmentID = envid
+	a.UpdatedBy = author
+	err := q.DB.Transaction(func(tx *gorm.DB) error {
+		var last int
+		if err := tx.Model(&QuickActionVersion{}).Where("name = ? AND environment_id = ?", a.Name, envid).Select("COALESCE(MAX(version), 0)").Scan(&last).Error; err != nil {
+			return fmt.Errorf("Scan %v", err)
+		}
+		a.Version = last + 1
+		change := "updated"
+		var current QuickAction
+		res := tx.Where("name = ? AND environment_id = ?", a.Name, envid).Limit(1).Find(&current)
+		if res.Error != nil {
+			return fmt.Errorf("Find %v", res.Error)
+		}
+		if res.RowsAffected == 0 {
+			change = "created"
+			a.Creator = author
+			if err := tx.Create(&a).Error; err != nil {
+				return fmt.Errorf("Create %v", err)
+			}
+		} else {
+			a.ID = current.ID
+			a.CreatedAt = current.CreatedAt
+			a.Creator = current.Creator
+			if err := tx.Save(&a).Error; err != nil {
+				return fmt.Errorf("Save %v", err)
+			}
+		}
+		v := quickActionVersion(a, author, change)
+		if err := tx.Create(&v).Error; err != nil {
+			return fmt.Errorf("Create %v", err)
+		}
+		return nil
+	})
+	return a, err
+}
+
+// DeleteQuickAction to delete a quick action of an environment, its versions are kept
+func (q *Queries) DeleteQuickAction(name string, envid uint, author string) error {
+	a, err := q.GetQuickAction(name, envid)
+	if err != nil {
+		return fmt.Errorf("error getting quick action %v", err)
+	}
+	return q.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&a).Error; err != nil {
+			return fmt.Errorf("Delete %v", err)
+		}
+		a.Version++
+		v := quickActionVersion(a, author, "deleted")
+		if err := tx.Create(&v).Error; err != nil {
+			return fmt.Errorf("Create %v", err)
+		}
+		return nil
+	})
+}
+
+// Helper to create a query of a quick action targeting nodes by UUID
+func (q *Queries) launchQuickQuery(query DistributedQuery, uuids []string) error {
+	if err := q.Create(query); err != nil {
+		return fmt.Errorf("error creating query %v", err)
+	}
+	for _, u := range uuids {
+		if err := q.CreateTarget(query.Name, QueryTargetUUID, u); err != nil {
+			return fmt.Errorf("error creating query target %v", err)
+		}
+	}
+	if _, err := q.Materialize(query.Name, query.EnvironmentID); err != nil {
+		return fmt.Errorf("error materializing query %v", err)
+	}
+	return q.SetExpected(query.Name, len(uuids), query.EnvironmentID)
+}
+
+// LaunchQuickAction to create the queries of a planned quick action in the targeted nodes, returning their names.
+// Accelerations are one hidden query per node, because only queries for one node accelerate check-ins.
+// Tags are not queries, they are applied by the caller.
+func (q *Queries) LaunchQuickAction(plan QuickActionPlan, envid uint, creator string, expiresAt time.Time) ([]string, error) {
+	base := DistributedQuery{
+		Creator:         creator,
+		Query:           plan.Query,
+		Active:          true,
+		Type:            StandardQueryType,
+		EnvironmentID:   envid,
+		TemplateName:    plan.Template,
+		TemplateVersion: plan.TemplateVersion,
+		Description:     fmt.Sprintf("Quick action %s (v%d)", plan.Action, plan.Version),
+		ExpiresAt:       expiresAt,
+	}
+	switch plan.Type {
+	case QuickActionQuery:
+		base.Name = GenQueryName()
+		return []string{base.Name}, q.launchQuickQuery(base, plan.Targets)
+	case QuickActionCarve:
+		base.Name = GenCarveName()
+		base.Type = CarveQueryType
+		base.Path = plan.Path
+		return []string{base.Name}, q.launchQuickQuery(base, plan.Targets)
+	case QuickActionAccelerate:
+		var names []string
+		for _, u := range plan.Targets {
+			query := base
+			query.Name = GenQueryName()
+			query.Hidden = true
+			if err := q.launchQuickQuery(query, []string{u}); err != nil {
+				return names, err
+			}
+			names = append(names, query.Name)
+		}
+		return names, nil
+	}
+	return nil, nil
+}
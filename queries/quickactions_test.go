@@ -0,0 +1,80 @@
+package queries
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateQuickAction(t *testing.T) {
+	assert.NoError(t, ValidateQuickAction(QuickAction{Name: "users", Label: "Users", Type: QuickActionQuery, Template: "users"}))
+	assert.NoError(t, ValidateQuickAction(QuickAction{Name: "hosts", Label: "Hosts", Icon: "fas fa-file", Type: QuickActionCarve, Path: "/etc/hosts"}))
+	assert.NoError(t, ValidateQuickAction(QuickAction{Name: "suspect", Label: "Suspect", Type: QuickActionTag, Tag: "suspect"}))
+	assert.NoError(t, ValidateQuickAction(QuickAction{Name: "faster", Label: "Faster", Type: QuickActionAccelerate}))
+	assert.Error(t, ValidateQuickAction(QuickAction{Name: "Bad Name", Label: "Bad", Type: QuickActionAccelerate}))
+	assert.Error(t, ValidateQuickAction(QuickAction{Name: "nolabel", Type: QuickActionAccelerate}))
+	assert.Error(t, ValidateQuickAction(QuickAction{Name: "icon", Label: "Icon", Icon: "\" onclick=\"x", Type: QuickActionAccelerate}))
+	assert.Error(t, ValidateQuickAction(QuickAction{Name: "sql", Label: "SQL", Type: "sql"}))
+	assert.Error(t, ValidateQuickAction(QuickAction{Name: "notemplate", Label: "Query", Type: QuickActionQuery}))
+	assert.Error(t, ValidateQuickAction(QuickAction{Name: "mixed", Label: "Mixed", Type: QuickActionQuery, Template: "users", Path: "/etc"}))
+	assert.Error(t, ValidateQuickAction(QuickAction{Name: "nopath", Label: "Carve", Type: QuickActionCarve}))
+	assert.Error(t, ValidateQuickAction(QuickAction{Name: "notag", Label: "Tag", Type: QuickActionTag}))
+}
+
+func TestParseQuickActionVariables(t *testing.T) {
+	vars, err := ParseQuickActionVariables(" user = root ,path=/tmp,")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"user": "root", "path": "/tmp"}, vars)
+	assert.Equal(t, "path=/tmp,user=root", EncodeQuickActionVariables(vars))
+	_, err = ParseQuickActionVariables("user")
+	assert.Error(t, err)
+	vars, err = ParseQuickActionVariables("")
+	require.NoError(t, err)
+	assert.Empty(t, vars)
+}
+
+func TestPlanQuickActionQuery(t *testing.T) {
+	tpl := QueryTemplate{Name: "user", Query: "SELECT * FROM users WHERE username = {{username}};", Variables: `[{"name":"username"}]`, Approved: true, Version: 3}
+	a := QuickAction{Name: "root", Label: "Root", Type: QuickActionQuery, Template: "user", Variables: `{"username":"root"}`, Version: 2}
+	plan, err := PlanQuickAction(a, &tpl, []string{"uuid1", "uuid1", " ", "uuid2"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"uuid1", "uuid2"}, plan.Targets)
+	assert.Equal(t, "SELECT * FROM users WHERE username = 'root';", plan.Query)
+	assert.Equal(t, 3, plan.TemplateVersion)
+	assert.Contains(t, plan.Summary, plan.Query)
+	// Only approved templates can run
+	tpl.Approved = false
+	_, err = PlanQuickAction(a, &tpl, []string{"uuid1"})
+	assert.Error(t, err)
+	_, err = PlanQuickAction(a, nil, []string{"uuid1"})
+	assert.Error(t, err)
+}
+
+func TestPlanQuickActionTargets(t *testing.T) {
+	a := QuickAction{Name: "faster", Label: "Faster", Type: QuickActionAccelerate}
+	_, err := PlanQuickAction(a, nil, nil)
+	assert.Error(t, err)
+	var many []string
+	for i := 0; i <= MaxQuickActionTargets; i++ {
+		many = append(many, strings.Repeat("a", i+1))
+	}
+	_, err = PlanQuickAction(a, nil, many)
+	assert.Error(t, err)
+	plan, err := PlanQuickAction(a, nil, []string{"uuid1"})
+	require.NoError(t, err)
+	assert.Equal(t, AccelerateQuery, plan.Query)
+}
+
+func TestPlanQuickActionCarveAndTag(t *testing.T) {
+	plan, err := PlanQuickAction(QuickAction{Name: "hosts", Label: "Hosts", Type: QuickActionCarve, Path: "/tmp/it's"}, nil, []string{"uuid1"})
+	require.NoError(t, err)
+	assert.Equal(t, GenRecurringCarveQuery([]string{"/tmp/it's"}), plan.Query)
+	assert.Equal(t, "/tmp/it's", plan.Path)
+	plan, err = PlanQuickAction(QuickAction{Name: "suspect", Label: "Suspect", Type: QuickActionTag, Tag: "suspect"}, nil, []string{"uuid1"})
+	require.NoError(t, err)
+	assert.Empty(t, plan.Query)
+	assert.Equal(t, "suspect", plan.Tag)
+	assert.Equal(t, "quick action suspect (v0) run on uuid1", QuickActionRunSummary(plan, nil))
+}
@@ -0,0 +1,512 @@
+package queries
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmpsec/osctrl/nodes"
+	"gorm.io/gorm"
+)
+
+const (
+	// RecurringActive for recurring carves that launch carves in each occurrence
+	RecurringActive string = "active"
+	// RecurringPaused for recurring carves that skip occurrences until resumed
+	RecurringPaused string = "paused"
+	// RecurringStopped for recurring carves that do not launch more carves
+	RecurringStopped string = "stopped"
+	// RunLaunched for occurrences that launched a carve in a node
+	RunLaunched string = "launched"
+	// RunMissed for occurrences skipped in a node, because it was offline or unknown
+	RunMissed string = "missed"
+	// MinRecurringInterval is the minimum interval between occurrences of a recurring carve
+	MinRecurringInterval = time.Hour
+	// DefaultRecurringInterval is the default interval to check for due recurring carves
+	DefaultRecurringInterval = time.Minute
+)
+
+// RecurringCarve to launch the same carve in nodes on a schedule, tagged with the case of a legal hold
+type RecurringCarve struct {
+	gorm.Model
+	Name          string `gorm:"not null;unique;index"`
+	CaseID        string `gorm:"index"`
+	EnvironmentID uint   `gorm:"index"`
+	Paths         string
+	UUIDs         string
+	Recurrence    string
+	Status        string
+	EndAt         time.Time
+	LastRun       time.Time
+	NextRun       time.Time
+	Creator       string
+	StoppedBy     string
+	StoppedAt     time.Time
+}
+
+// RecurringCarveRun to keep what happened in one node for one occurrence of a recurring carve
+type RecurringCarveRun struct {
+	gorm.Model
+	RecurringID uint      `gorm:"index"`
+	CaseID      string    `gorm:"index"`
+	Occurrence  time.Time `gorm:"index"`
+	UUID        string    `gorm:"index"`
+	QueryName   string    `gorm:"index"`
+	Status      string
+	Reason      string
+}
+
+// CaseFootprint to show the storage used by the carves of a case, across all occurrences
+type CaseFootprint struct {
+	CaseID string `json:"case_id"`
+	Bytes  int64  `json:"bytes"`
+	Carves int64  `json:"carves"`
+}
+
+// RecurringCarveDetail to show a recurring carve with its occurrences in each node and the storage of its case
+type RecurringCarveDetail struct {
+	Recurring RecurringCarve      `json:"recurring"`
+	Runs      []RecurringCarveRun `json:"runs"`
+	Footprint CaseFootprint       `json:"footprint"`
+}
+
+// Recurrence to get the occurrences of a recurring carve, every interval or matching a cron expression
+type Recurrence struct {
+	interval time.Duration
+	fields   [5]uint64
+	domStar  bool
+	dowStar  bool
+}
+
+// Limits of the fields of cron expressions: minute, hour, day of month, month and day of week
+var cronLimits = [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 6}}
+
+// Shortcuts of cron expressions
+var cronShortcuts = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// Helper to parse one field of a cron expression, with lists, ranges and steps
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			s, err := strconv.Atoi(part[i+1:])
+			if err != nil || s <= 0 {
+				return 0, fmt.Errorf("invalid step %s", part)
+			}
+			step = s
+			part = part[:i]
+		}
+		low, high := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			l, err := strconv.Atoi(bounds[0])
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %s", part)
+			}
+			low, high = l, l
+			if len(bounds) == 2 {
+				if high, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid value %s", part)
+				}
+			} else if step > 1 {
+				high = max
+			}
+		}
+		if low < min || high > max || low > high {
+			return 0, fmt.Errorf("value %s out of range %d-%d", part, min, max)
+		}
+		for v := low; v <= high; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// ParseRecurrence to parse an interval like 24h, or a cron expression of five fields in UTC like 0 2 * * *
+func ParseRecurrence(value string) (Recurrence, error) {
+	var r Recurrence
+	value = strings.TrimSpace(value)
+	if interval, err := time.ParseDuration(value); err == nil {
+		if interval < MinRecurringInterval {
+			return r, fmt.Errorf("interval %s is shorter than %s", value, MinRecurringInterval)
+		}
+		r.interval = interval
+		return r, nil
+	}
+	if shortcut, ok := cronShortcuts[value]; ok {
+		value = shortcut
+	}
+	fields := strings.Fields(value)
+	if len(fields) != len(cronLimits) {
+		return r, fmt.Errorf("invalid recurrence %s, it must be an interval or a cron expression", value)
+	}
+	for i, f := range fields {
+		bits, err := parseCronField(f, cronLimits[i][0], cronLimits[i][1])
+		if err != nil {
+			return r, fmt.Errorf("invalid recurrence %s: %v", value, err)
+		}
+		r.fields[i] = bits
+	}
+	// Occurrences can not be more frequent than the minimum interval
+	if r.fields[0]&(r.fields[0]-1) != 0 {
+		return r, fmt.Errorf("recurrence %s runs more than once per hour", value)
+	}
+	r.domStar = fields[2] == "*"
+	r.dowStar = fields[4] == "*"
+	return r, nil
+}
+
+// Helper to check if a day matches a cron expression, days of month and week match either when both are set
+func (r Recurrence) matchDay(t time.Time) bool {
+	dom := r.fields[2]&(1<<uint(t.Day())) != 0
+	dow := r.fields[4]&(1<<uint(t.Weekday())) != 0
+	if r.domStar || r.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next to get the next occurrence strictly after the provided time, zero if there is none in five years
+func (r Recurrence) Next(after time.Time) time.Time {
+	if r.interval > 0 {
+		return after.Add(r.interval)
+	}
+	t := after.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if r.fields[3]&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !r.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if r.fields[1]&(1<<uint(t.Hour())) == 0 {
+			t = t.Truncate(time.Hour).Add(time.Hour)
+			continue
+		}
+		if r.fields[0]&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// GenRecurringCarveQuery to generate the carve query of all the paths of a recurring carve
+func GenRecurringCarveQuery(paths []string) string {
+	conditions := make([]string, 0, len(paths))
+	for _, p := range paths {
+		conditions = append(conditions, "path = '"+strings.ReplaceAll(p, "'", "''")+"'")
+	}
+	return "SELECT * FROM carves WHERE carve=1 AND (" + strings.Join(conditions, " OR ") + ");"
+}
+
+// Helper to split a list of values kept in one column
+func splitValues(value, sep string) []string {
+	var values []string
+	for _, v := range strings.Split(value, sep) {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// PathList to get the paths captured by a recurring carve
+func (r RecurringCarve) PathList() []string {
+	return splitValues(r.Paths, "\n")
+}
+
+// UUIDList to get the nodes targeted by a recurring carve
+func (r RecurringCarve) UUIDList() []string {
+	return splitValues(r.UUIDs, ",")
+}
+
+// Ended to check if a recurring carve reached its end date
+func (r RecurringCarve) Ended(now time.Time) bool {
+	return !r.EndAt.IsZero() && !now.Before(r.EndAt)
+}
+
+// NewRecurringCarve to add a carve of paths launched in nodes on a recurrence, until the end date if set or until stopped
+func (q *Queries) NewRecurringCarve(envid uint, caseID string, paths, uuids []string, recurrence string, endAt time.Time, creator string) (RecurringCarve, error) {
+	caseID = strings.TrimSpace(caseID)
+	if caseID == "" {
+		return RecurringCarve{}, fmt.Errorf("case identifier can not be empty")
+	}
+	paths = splitValues(strings.Join(paths, "\n"), "\n")
+	if len(paths) == 0 {
+		return RecurringCarve{}, fmt.Errorf("paths can not be empty")
+	}
+	uuids = splitValues(strings.Join(uuids, ","), ",")
+	if len(uuids) == 0 {
+		return RecurringCarve{}, fmt.Errorf("nodes can not be empty")
+	}
+	rec, err := ParseRecurrence(recurrence)
+	if err != nil {
+		return RecurringCarve{}, err
+	}
+	now := time.Now()
+	if !endAt.IsZero() && !endAt.After(now) {
+		return RecurringCarve{}, fmt.Errorf("end date must be in the future")
+	}
+	// Intervals run for the first time right away, cron expressions on the next match
+	next := now
+	if rec.interval == 0 {
+		next = rec.Next(now)
+	}
+	recurring := RecurringCarve{
+		Name:          GenRecurringCarveName(),
+		CaseID:        caseID,
+		EnvironmentID: envid,
+		Paths:         strings.Join(paths, "\n"),
+		UUIDs:         strings.Join(uuids, ","),
+		Recurrence:    strings.TrimSpace(recurrence),
+		Status:        RecurringActive,
+		EndAt:         endAt,
+		NextRun:       next,
+		Creator:       creator,
+	}
+	if err := q.DB.Create(&recurring).Error; err != nil {
+		return recurring, fmt.Errorf("Create %v", err)
+	}
+	return recurring, nil
+}
+
+// GetRecurringCarves to retrieve the recurring carves of an environment, newest first
+func (q *Queries) GetRecurringCarves(envid uint) ([]RecurringCarve, error) {
+	var recurring []RecurringCarve
+	if err := q.read().Where("environment_id = ?", envid).Order("created_at DESC").Find(&recurring).Error; err != nil {
+		return recurring, err
+	}
+	return recurring, nil
+}
+
+// GetRecurringCarve to retrieve a recurring carve by name
+func (q *Queries) GetRecurringCarve(name string, envid uint) (RecurringCarve, error) {
+	var recurring RecurringCarve
+	if err := q.DB.Where("name = ? AND environment_id = ?", name, envid).First(&recurring).Error; err != nil {
+		return recurring, err
+	}
+	return recurring, nil
+}
+
+// Helper to change the status of a recurring carve, only from the provided statuses
+func (q *Queries) setRecurringStatus(name string, envid uint, from []string, values map[string]interface{}) error {
+	recurring, err := q.GetRecurringCarve(name, envid)
+	if err != nil {
+		return err
+	}
+	allowed := false
+	for _, f := range from {
+		if recurring.Status == f {
+			allowed = true
+		}
+	}
+	if !allowed {
+		return fmt.Errorf("recurring carve %s is %s", name, recurring.Status)
+	}
+	if err := q.DB.Model(&recurring).Updates(values).Error; err != nil {
+		return fmt.Errorf("Updates %v", err)
+	}
+	return nil
+}
+
+// PauseRecurringCarve to skip the occurrences of a recurring carve until it is resumed
+func (q *Queries) PauseRecurringCarve(name string, envid uint) error {
+	return q.setRecurringStatus(name, envid, []string{RecurringActive}, map[string]interface{}{"status": RecurringPaused})
+}
+
+// ResumeRecurringCarve to launch again a paused recurring carve, from the next occurrence after now
+func (q *Queries) ResumeRecurringCarve(name string, envid uint) error {
+	recurring, err := q.GetRecurringCarve(name, envid)
+	if err != nil {
+		return err
+	}
+	rec, err := ParseRecurrence(recurring.Recurrence)
+	if err != nil {
+		return err
+	}
+	return q.setRecurringStatus(name, envid, []string{RecurringPaused}, map[string]interface{}{"status": RecurringActive, "next_run": rec.Next(time.Now())})
+}
+
+// StopRecurringCarve to stop a recurring carve for good, the carves already collected are kept
+func (q *Queries) StopRecurringCarve(name string, envid uint, user string) error {
+	return q.setRecurringStatus(name, envid, []string{RecurringActive, RecurringPaused}, map[string]interface{}{
+		"status":     RecurringStopped,
+		"stopped_by": user,
+		"stopped_at": time.Now(),
+	})
+}
+
+// RecurringRuns to retrieve what happened in each node for the occurrences of a recurring carve, newest first
+func (q *Queries) RecurringRuns(id uint) ([]RecurringCarveRun, error) {
+	var runs []RecurringCarveRun
+	if err := q.read().Where("recurring_id = ?", id).Order("occurrence DESC, uuid").Find(&runs).Error; err != nil {
+		return runs, err
+	}
+	return runs, nil
+}
+
+// CaseQueryNames to retrieve the names of the carves launched for a case
+func (q *Queries) CaseQueryNames(caseID string) ([]string, error) {
+	var names []string
+	if err := q.read().Model(&RecurringCarveRun{}).Where("case_id = ? AND status = ?", caseID, RunLaunched).Distinct().Pluck("query_name", &names).Error; err != nil {
+		return names, err
+	}
+	return names, nil
+}
+
+// Helper to scope a query to the cases with an active hold, the ones with recurring carves not stopped
+func heldCases(db *gorm.DB) *gorm.DB {
+	return db.Model(&RecurringCarve{}).Select("case_id").Where("status <> ?", RecurringStopped)
+}
+
+// claimRecurring to move a due recurring carve to its next occurrence, only one instance of the service gets it
+func (q *Queries) claimRecurring(recurring RecurringCarve, now, next time.Time) (bool, error) {
+	res := q.DB.Model(&RecurringCarve{}).Where("id = ? AND next_run = ? AND status = ?", recurring.ID, recurring.NextRun, RecurringActive).Updates(map[string]interface{}{
+		"last_run": now,
+		"next_run": next,
+	})
+	if res.Error != nil {
+		return false, fmt.Errorf("Updates %v", res.Error)
+	}
+	return res.RowsAffected == 1, nil
+}
+
+// launchOccurrence to launch a new carve in the nodes of a recurring carve that are online, recording the misses
+func (q *Queries) launchOccurrence(recurring RecurringCarve, now time.Time, inactiveHours int64) (string, error) {
+	uuids := recurring.UUIDList()
+	var found []nodes.OsqueryNode
+	if err := q.DB.Where("uuid IN ? AND environment_id = ?", uuids, recurring.EnvironmentID).Find(&found).Error; err != nil {
+		return "", fmt.Errorf("Find %v", err)
+	}
+	byUUID := make(map[string]nodes.OsqueryNode, len(found))
+	for _, n := range found {
+		byUUID[n.UUID] = n
+	}
+	name := GenCarveName()
+	runs := make([]RecurringCarveRun, 0, len(uuids))
+	var online []string
+	for _, u := range uuids {
+		run := RecurringCarveRun{RecurringID: recurring.ID, CaseID: recurring.CaseID, Occurrence: now, UUID: u, Status: RunMissed}
+		n, ok := byUUID[u]
+		switch {
+		case !ok:
+			run.Reason = "node not found"
+		case !nodes.IsActive(n, inactiveHours):
+			run.Reason = "node offline"
+		default:
+			run.Status = RunLaunched
+			run.QueryName = name
+			online = append(online, u)
+		}
+		runs = append(runs, run)
+	}
+	if len(online) > 0 {
+		carve := DistributedQuery{
+			Query:         GenRecurringCarveQuery(recurring.PathList()),
+			Name:          name,
+			Creator:       recurring.Creator,
+			Active:        true,
+			Type:          CarveQueryType,
+			Path:          strings.Join(recurring.PathList(), ", "),
+			EnvironmentID: recurring.EnvironmentID,
+			CaseID:        recurring.CaseID,
+			Description:   fmt.Sprintf("Recurring carve %s of case %s", recurring.Name, recurring.CaseID),
+		}
+		if err := q.Create(carve); err != nil {
+			return "", fmt.Errorf("error creating carve %v", err)
+		}
+		for _, u := range online {
+			if err := q.CreateTarget(name, QueryTargetUUID, u); err != nil {
+				return "", fmt.Errorf("error creating carve target %v", err)
+			}
+		}
+		if _, err := q.Materialize(name, recurring.EnvironmentID); err != nil {
+			return "", fmt.Errorf("error materializing carve %v", err)
+		}
+		if err := q.SetExpected(name, len(online), recurring.EnvironmentID); err != nil {
+			return "", fmt.Errorf("error setting expected %v", err)
+		}
+	} else {
+		name = ""
+	}
+	if err := q.DB.Create(&runs).Error; err != nil {
+		return name, fmt.Errorf("Create %v", err)
+	}
+	return name, nil
+}
+
+// RunRecurringCarves to launch the due occurrences of recurring carves, stopping the ones that reached the end date
+func (q *Queries) RunRecurringCarves(now time.Time, inactiveHours int64) int {
+	var due []RecurringCarve
+	if err := q.DB.Where("status = ? AND next_run <= ?", RecurringActive, now).Find(&due).Error; err != nil {
+		log.Printf("error getting recurring carves %v", err)
+		return 0
+	}
+	launched := 0
+	for _, r := range due {
+		if r.Ended(now) {
+			if err := q.DB.Model(&r).Updates(map[string]interface{}{"status": RecurringStopped, "stopped_at": r.EndAt}).Error; err != nil {
+				log.Printf("error stopping recurring carve %s %v", r.Name, err)
+			}
+			continue
+		}
+		rec, err := ParseRecurrence(r.Recurrence)
+		if err != nil {
+			log.Printf("error with recurring carve %s %v", r.Name, err)
+			continue
+		}
+		// With several instances running, only the one that claims the occurrence launches the carve
+		claimed, err := q.claimRecurring(r, now, rec.Next(now))
+		if err != nil {
+			log.Printf("error claiming recurring carve %s %v", r.Name, err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+		name, err := q.launchOccurrence(r, now, inactiveHours)
+		if err != nil {
+			log.Printf("error launching recurring carve %s %v", r.Name, err)
+			continue
+		}
+		if name != "" {
+			launched++
+		}
+	}
+	return launched
+}
+
+// StartRecurringCarves to launch the due occurrences of recurring carves periodically, until ctx is cancelled
+func (q *Queries) StartRecurringCarves(ctx context.Context, interval time.Duration, inactiveHours func() int64) {
+	if interval <= 0 {
+		interval = DefaultRecurringInterval
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if launched := q.RunRecurringCarves(time.Now(), inactiveHours()); launched > 0 {
+					log.Printf("Launched %d recurring carves", launched)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,66 @@
+package queries
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRecurrence(t *testing.T) {
+	r, err := ParseRecurrence("24h")
+	assert.NoError(t, err)
+	assert.Equal(t, 24*time.Hour, r.interval)
+	_, err = ParseRecurrence("30m")
+	assert.Error(t, err)
+	for _, v := range []string{"0 2 * * *", "15 */6 * * 1-5", "0 0 1,15 * *", "@daily", "@weekly"} {
+		_, err = ParseRecurrence(v)
+		assert.NoError(t, err, v)
+	}
+	for _, v := range []string{"", "yesterday", "0 2 * *", "0 24 * * *", "0 2 * 13 *", "0 5-2 * * *", "0 */0 * * *"} {
+		_, err = ParseRecurrence(v)
+		assert.Error(t, err, v)
+	}
+	// More than once per hour is not allowed
+	_, err = ParseRecurrence("*/30 * * * *")
+	assert.Error(t, err)
+	_, err = ParseRecurrence("* 2 * * *")
+	assert.Error(t, err)
+}
+
+func TestRecurrenceNext(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 30, 0, 0, time.UTC)
+	r, _ := ParseRecurrence("0 2 * * *")
+	assert.Equal(t, time.Date(2024, 5, 11, 2, 0, 0, 0, time.UTC), r.Next(now))
+	// Strictly after the provided time
+	assert.Equal(t, time.Date(2024, 5, 12, 2, 0, 0, 0, time.UTC), r.Next(time.Date(2024, 5, 11, 2, 0, 0, 0, time.UTC)))
+	// 2024-05-10 is a Friday, next Monday is the 13th
+	r, _ = ParseRecurrence("0 9 * * 1")
+	assert.Equal(t, time.Date(2024, 5, 13, 9, 0, 0, 0, time.UTC), r.Next(now))
+	r, _ = ParseRecurrence("@monthly")
+	assert.Equal(t, time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), r.Next(now))
+	r, _ = ParseRecurrence("0 0 1 1 *")
+	assert.Equal(t, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), r.Next(now))
+	// Days of month and week match either when both are set
+	r, _ = ParseRecurrence("0 0 20 * 1")
+	assert.Equal(t, time.Date(2024, 5, 13, 0, 0, 0, 0, time.UTC), r.Next(now))
+	// Days that never happen have no occurrence
+	r, _ = ParseRecurrence("0 0 31 2 *")
+	assert.True(t, r.Next(now).IsZero())
+	r, _ = ParseRecurrence("6h")
+	assert.Equal(t, now.Add(6*time.Hour), r.Next(now))
+}
+
+func TestGenRecurringCarveQuery(t *testing.T) {
+	assert.Equal(t, "SELECT * FROM carves WHERE carve=1 AND (path = '/etc/passwd');", GenRecurringCarveQuery([]string{"/etc/passwd"}))
+	assert.Equal(t, "SELECT * FROM carves WHERE carve=1 AND (path = '/tmp/a' OR path = '/tmp/it''s');", GenRecurringCarveQuery([]string{"/tmp/a", "/tmp/it's"}))
+}
+
+func TestRecurringCarveLists(t *testing.T) {
+	rc := RecurringCarve{Paths: "/tmp/a\n\n/tmp/b ", UUIDs: "AAA, BBB,", EndAt: time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC)}
+	assert.Equal(t, []string{"/tmp/a", "/tmp/b"}, rc.PathList())
+	assert.Equal(t, []string{"AAA", "BBB"}, rc.UUIDList())
+	assert.True(t, rc.Ended(rc.EndAt))
+	assert.False(t, rc.Ended(rc.EndAt.Add(-time.Minute)))
+	assert.False(t, RecurringCarve{}.Ended(rc.EndAt))
+}
@@ -3,6 +3,7 @@ package queries
 import (
 	"fmt"
 
+	"github.com/jmpsec/osctrl/backend"
 	"gorm.io/gorm"
 )
 
@@ -14,6 +15,7 @@ type SavedQuery struct {
 	Query         string
 	EnvironmentID uint
 	ExtraData     string
+	DeletedBy     string
 }
 
 // GetSavedByCreator to get a saved query by creator
@@ -65,13 +67,16 @@ func (q *Queries) UpdateSaved(name, query, creator string, envid uint) error {
 	return nil
 }
 
-// DeleteSaved to delete an existing saved query
+// DeleteSaved to move a saved query to the trash, it can be restored by its creator within the window
 func (q *Queries) DeleteSaved(name, creator string, envid uint) error {
 	saved, err := q.GetSaved(name, creator, envid)
 	if err != nil {
 		return fmt.Errorf("error getting saved query %v", err)
 	}
-	if err := q.DB.Unscoped().Delete(&saved).Error; err != nil {
+	if saved.ID == 0 {
+		return fmt.Errorf("saved query %s not found", name)
+	}
+	if err := backend.SoftDelete(q.DB, &saved, creator); err != nil {
 		return fmt.Errorf("DeleteSaved %v", err)
 	}
 	return nil
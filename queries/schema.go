@@ -0,0 +1,188 @@
+package queries
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Types of columns inferred from the results of queries
+const (
+	ColumnInt       string = "int"
+	ColumnFloat     string = "float"
+	ColumnBool      string = "bool"
+	ColumnTimestamp string = "timestamp"
+	ColumnString    string = "string"
+)
+
+// Layouts of values inferred as timestamps
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+}
+
+// ResultColumn to describe one column of the results of a query, with the rows where it is null
+type ResultColumn struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Nulls int    `json:"nulls"`
+}
+
+// ResultSchema to describe the columns of the results of a query, inferred across all the rows
+type ResultSchema struct {
+	Rows    int            `json:"rows"`
+	Columns []ResultColumn `json:"columns"`
+}
+
+// SchemaInference to infer the schema of the results of a query, one row at a time
+type SchemaInference struct {
+	rows    int
+	types   map[string]string
+	present map[string]int
+}
+
+// NewSchemaInference to start inferring the schema of the results of a query
+func NewSchemaInference() *SchemaInference {
+	return &SchemaInference{
+		types:   make(map[string]string),
+		present: make(map[string]int),
+	}
+}
+
+// Add to use one row of results for the inference. Empty values are nulls and do not change the type.
+func (s *SchemaInference) Add(row map[string]string) {
+	s.rows++
+	for name, v := range row {
+		if _, ok := s.types[name]; !ok {
+			s.types[name] = ""
+		}
+		if v == "" {
+			continue
+		}
+		s.present[name]++
+		s.types[name] = mergeColumnTypes(s.types[name], ValueType(v))
+	}
+}
+
+// Schema to get the inferred schema, with columns sorted by name.
+// Columns that were always null are strings.
+func (s *SchemaInference) Schema() ResultSchema {
+	schema := ResultSchema{Rows: s.rows, Columns: []ResultColumn{}}
+	for name, t := range s.types {
+		if t == "" {
+			t = ColumnString
+		}
+		schema.Columns = append(schema.Columns, ResultColumn{Name: name, Type: t, Nulls: s.rows - s.present[name]})
+	}
+	sort.Slice(schema.Columns, func(i, j int) bool {
+		return schema.Columns[i].Name < schema.Columns[j].Name
+	})
+	return schema
+}
+
+// ValueType to get the type of one value of results
+func ValueType(v string) string {
+	if v == "true" || v == "false" {
+		return ColumnBool
+	}
+	if _, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return ColumnInt
+	}
+	// Only plain numbers are floats, not inf or nan
+	if strings.IndexAny(v, "0123456789") >= 0 && !strings.ContainsAny(v, "inxINX") {
+		if _, err := strconv.ParseFloat(v, 64); err == nil {
+			return ColumnFloat
+		}
+	}
+	if _, ok := parseTimestamp(v); ok {
+		return ColumnTimestamp
+	}
+	return ColumnString
+}
+
+// Helper to merge the type of a column with the type of a new value, mixed types fall back to string
+func mergeColumnTypes(current, value string) string {
+	switch {
+	case current == "" || current == value:
+		return value
+	case (current == ColumnInt && value == ColumnFloat) || (current == ColumnFloat && value == ColumnInt):
+		return ColumnFloat
+	}
+	return ColumnString
+}
+
+// Helper to parse a value as timestamp with any of the supported layouts
+func parseTimestamp(v string) (time.Time, bool) {
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// TypedValue to convert one value of results to the type of its column, nil for nulls
+func TypedValue(columnType, v string) interface{} {
+	if v == "" && columnType != ColumnString {
+		return nil
+	}
+	switch columnType {
+	case ColumnInt:
+		if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return i
+		}
+	case ColumnFloat:
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f
+		}
+	case ColumnBool:
+		return v == "true"
+	case ColumnTimestamp:
+		if t, ok := parseTimestamp(v); ok {
+			return t.UTC()
+		}
+	case ColumnString:
+		return v
+	}
+	return nil
+}
+
+// ParseResultRows to parse the rows of the result of a query for one node, numbers and strings are kept as
+// sent by osquery and null values are missing from the row
+func ParseResultRows(result json.RawMessage) ([]map[string]string, error) {
+	rows := []map[string]string{}
+	if len(bytes.TrimSpace(result)) == 0 || string(bytes.TrimSpace(result)) == "null" || string(bytes.TrimSpace(result)) == `""` {
+		return rows, nil
+	}
+	var raw []map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(result))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return rows, fmt.Errorf("Decode %v", err)
+	}
+	for _, r := range raw {
+		row := make(map[string]string, len(r))
+		for k, v := range r {
+			switch value := v.(type) {
+			case nil:
+				continue
+			case string:
+				row[k] = value
+			case json.Number:
+				row[k] = value.String()
+			case bool:
+				row[k] = strconv.FormatBool(value)
+			default:
+				b, _ := json.Marshal(value)
+				row[k] = string(b)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
@@ -0,0 +1,459 @@
+package queries
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jmpsec/osctrl/nodes"
+	"gorm.io/gorm"
+)
+
+const (
+	// SharePending for sharing exports waiting to be generated
+	SharePending string = "pending"
+	// ShareReady for sharing exports generated and ready to download
+	ShareReady string = "ready"
+	// ShareFailed for sharing exports that could not be generated
+	ShareFailed string = "failed"
+	// ShareManifestFile is the file of sharing exports with the hashes of all the other files
+	ShareManifestFile string = "manifest.json"
+	// ShareNodesFile is the file of sharing exports with the nodes that sent results
+	ShareNodesFile string = "nodes.json"
+	// ShareRedacted replaces the values removed from results of sharing exports
+	ShareRedacted string = "[redacted]"
+	// ShareContentType is the content type of sharing exports
+	ShareContentType string = "application/zip"
+	// MaxShareQueries is the maximum number of queries in one sharing export
+	MaxShareQueries int = 50
+)
+
+// DefaultShareDenyColumns to drop the columns of results with usernames, when no deny-list is provided
+var DefaultShareDenyColumns = []string{"*user*", "*login*"}
+
+// ShareFormats to list the formats of the results in sharing exports
+var ShareFormats = []string{ExportCSV, ExportJSON}
+
+// ShareRedaction to remove values from the results of sharing exports.
+// Columns matching a pattern of the deny-list are dropped, patterns like *user* are case insensitive.
+type ShareRedaction struct {
+	DropIPs     bool     `json:"drop_ips"`
+	DenyColumns []string `json:"deny_columns"`
+}
+
+// ShareExport to keep a bundle with queries and their results to share outside osctrl, generated asynchronously
+type ShareExport struct {
+	gorm.Model
+	Name          string `gorm:"not null;unique;index"`
+	EnvironmentID uint   `gorm:"index"`
+	Environment   string
+	Queries       string
+	Format        string
+	Redaction     string
+	Status        string
+	Error         string
+	Content       []byte `json:"-"`
+	Size          int64
+	SHA256        string
+	Creator       string
+}
+
+// ShareRequest to select the queries of one environment and the redaction of a sharing export
+type ShareRequest struct {
+	EnvironmentID uint
+	Environment   string
+	Queries       []string
+	Format        string
+	Redaction     ShareRedaction
+	Creator       string
+}
+
+// ShareQuery to hold the definition of a query in sharing exports
+type ShareQuery struct {
+	Name        string    `json:"name"`
+	Query       string    `json:"query"`
+	Creator     string    `json:"creator"`
+	Created     time.Time `json:"created"`
+	Expected    int       `json:"expected"`
+	Executions  int       `json:"executions"`
+	Errors      int       `json:"errors"`
+	Description string    `json:"description"`
+	TicketID    string    `json:"ticket_id"`
+}
+
+// ShareNode to hold the minimal metadata of a node in sharing exports
+type ShareNode struct {
+	UUID        string `json:"uuid"`
+	Hostname    string `json:"hostname"`
+	Platform    string `json:"platform"`
+	Environment string `json:"environment"`
+}
+
+// ShareFile to hold the size and the hash of a file of a sharing export, for chain of custody
+type ShareFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// ShareManifest to describe the scope of a sharing export with all its files
+type ShareManifest struct {
+	Name        string         `json:"name"`
+	Environment string         `json:"environment"`
+	Creator     string         `json:"creator"`
+	Generated   time.Time      `json:"generated"`
+	Queries     []string       `json:"queries"`
+	Format      string         `json:"format"`
+	Redaction   ShareRedaction `json:"redaction"`
+	Nodes       int            `json:"nodes"`
+	Rows        int            `json:"rows"`
+	Files       []ShareFile    `json:"files"`
+}
+
+// ShareSource to read the queries, results and nodes of sharing exports, so results can come from the DB or the cache
+type ShareSource struct {
+	Query func(name string) (DistributedQuery, error)
+	Rows  func(name string, fn func(node string, collected time.Time, row map[string]string) error) error
+	Node  func(uuid string) (nodes.OsqueryNode, error)
+}
+
+// ValidShareFormat to check if a format of results is supported in sharing exports
+func ValidShareFormat(format string) bool {
+	for _, f := range ShareFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// Denied to check if a column of results is dropped by the deny-list
+func (r ShareRedaction) Denied(column string) bool {
+	column = strings.ToLower(column)
+	for _, p := range r.DenyColumns {
+		if matched, _ := path.Match(strings.ToLower(p), column); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper to check if a value of results is an IP address, with or without port
+func isIPValue(value string) bool {
+	value = strings.TrimSpace(value)
+	if net.ParseIP(value) != nil {
+		return true
+	}
+	if _, _, err := net.ParseCIDR(value); err == nil {
+		return true
+	}
+	if host, _, err := net.SplitHostPort(value); err == nil && net.ParseIP(host) != nil {
+		return true
+	}
+	return false
+}
+
+// Row to apply the redaction to a row of results, the row is not modified
+func (r ShareRedaction) Row(row map[string]string) map[string]string {
+	redacted := make(map[string]string, len(row))
+	for column, value := range row {
+		if r.Denied(column) {
+			continue
+		}
+		if r.DropIPs && isIPValue(value) {
+			value = ShareRedacted
+		}
+		redacted[column] = value
+	}
+	return redacted
+}
+
+// Summary to describe the redaction for the audit log
+func (r ShareRedaction) Summary() string {
+	var parts []string
+	if r.DropIPs {
+		parts = append(parts, "IPs dropped")
+	}
+	if len(r.DenyColumns) > 0 {
+		parts = append(parts, "columns denied "+strings.Join(r.DenyColumns, ", "))
+	}
+	if len(parts) == 0 {
+		return "no redaction"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Validate to check a sharing export request, the default deny-list is used if none was provided
+func (req *ShareRequest) Validate() error {
+	if len(req.Queries) == 0 {
+		return fmt.Errorf("at least one query is required")
+	}
+	if len(req.Queries) > MaxShareQueries {
+		return fmt.Errorf("too many queries %d, maximum is %d", len(req.Queries), MaxShareQueries)
+	}
+	seen := make(map[string]bool)
+	for _, q := range req.Queries {
+		if q == "" || seen[q] {
+			return fmt.Errorf("invalid or repeated query %q", q)
+		}
+		seen[q] = true
+	}
+	if req.Format == "" {
+		req.Format = ExportJSON
+	}
+	if !ValidShareFormat(req.Format) {
+		return fmt.Errorf("invalid format %s", req.Format)
+	}
+	if req.Redaction.DenyColumns == nil {
+		req.Redaction.DenyColumns = DefaultShareDenyColumns
+	}
+	for _, p := range req.Redaction.DenyColumns {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid column pattern %s", p)
+		}
+	}
+	return nil
+}
+
+// Helper to make a query name safe as a directory of sharing exports
+func shareDir(name string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' || r == '_' || r == '.' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// Writer that keeps the size of what was written
+type countWriter struct {
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+// Helper to write a file to a sharing export, keeping its size and hash for the manifest
+func shareFile(zw *zip.Writer, name string, fn func(w io.Writer) error) (ShareFile, error) {
+	f, err := zw.Create(name)
+	if err != nil {
+		return ShareFile{}, err
+	}
+	hash := sha256.New()
+	counter := &countWriter{}
+	if err := fn(io.MultiWriter(f, hash, counter)); err != nil {
+		return ShareFile{}, fmt.Errorf("error writing %s - %v", name, err)
+	}
+	return ShareFile{Name: name, Size: counter.n, SHA256: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+// Helper to write a value as indented JSON
+func writeShareJSON(w io.Writer, value interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(value)
+}
+
+// WriteShareBundle to write a ZIP with the definitions and the redacted results of the queries, the nodes that
+// sent results and a manifest with the hashes of every other file. Only the nodes of the results are included.
+func WriteShareBundle(w io.Writer, name string, req ShareRequest, src ShareSource) (ShareManifest, error) {
+	manifest := ShareManifest{
+		Name:        name,
+		Environment: req.Environment,
+		Creator:     req.Creator,
+		Generated:   time.Now().UTC(),
+		Queries:     req.Queries,
+		Format:      req.Format,
+		Redaction:   req.Redaction,
+	}
+	zw := zip.NewWriter(w)
+	nodeUUIDs := make(map[string]bool)
+	for _, qName := range req.Queries {
+		query, err := src.Query(qName)
+		if err != nil {
+			return manifest, fmt.Errorf("error getting query %s - %v", qName, err)
+		}
+		if query.Type == CarveQueryType {
+			return manifest, fmt.Errorf("%s is a carve, only queries can be shared", qName)
+		}
+		dir := "queries/" + shareDir(qName) + "/"
+		definition := ShareQuery{
+			Name:        query.Name,
+			Query:       query.Query,
+			Creator:     query.Creator,
+			Created:     query.CreatedAt.UTC(),
+			Expected:    query.Expected,
+			Executions:  query.Executions,
+			Errors:      query.Errors,
+			Description: query.Description,
+			TicketID:    query.TicketID,
+		}
+		file, err := shareFile(zw, dir+"query.json", func(w io.Writer) error {
+			return writeShareJSON(w, definition)
+		})
+		if err != nil {
+			return manifest, err
+		}
+		manifest.Files = append(manifest.Files, file)
+		// Rows are read twice, first to infer the schema of the redacted results and then to export them
+		inference := NewSchemaInference()
+		if err := src.Rows(qName, func(node string, collected time.Time, row map[string]string) error {
+			inference.Add(req.Redaction.Row(row))
+			return nil
+		}); err != nil {
+			return manifest, fmt.Errorf("error reading results of %s - %v", qName, err)
+		}
+		file, err = shareFile(zw, dir+"results."+req.Format, func(w io.Writer) error {
+			exporter, err := NewResultExporter(w, req.Format, inference.Schema())
+			if err != nil {
+				return err
+			}
+			if err := src.Rows(qName, func(node string, collected time.Time, row map[string]string) error {
+				nodeUUIDs[strings.ToUpper(node)] = true
+				manifest.Rows++
+				return exporter.Write(node, collected, req.Redaction.Row(row))
+			}); err != nil {
+				return err
+			}
+			return exporter.Close()
+		})
+		if err != nil {
+			return manifest, err
+		}
+		manifest.Files = append(manifest.Files, file)
+	}
+	uuids := make([]string, 0, len(nodeUUIDs))
+	for uuid := range nodeUUIDs {
+		uuids = append(uuids, uuid)
+	}
+	sort.Strings(uuids)
+	shareNodes := make([]ShareNode, 0, len(uuids))
+	for _, uuid := range uuids {
+		n := ShareNode{UUID: uuid, Environment: req.Environment}
+		// Nodes removed since they sent results are only identified by UUID
+		if node, err := src.Node(uuid); err == nil {
+			n.Hostname = node.Hostname
+			n.Platform = node.Platform
+		}
+		shareNodes = append(shareNodes, n)
+	}
+	manifest.Nodes = len(shareNodes)
+	file, err := shareFile(zw, ShareNodesFile, func(w io.Writer) error {
+		return writeShareJSON(w, shareNodes)
+	})
+	if err != nil {
+		return manifest, err
+	}
+	manifest.Files = append(manifest.Files, file)
+	if _, err := shareFile(zw, ShareManifestFile, func(w io.Writer) error {
+		return writeShareJSON(w, manifest)
+	}); err != nil {
+		return manifest, err
+	}
+	return manifest, zw.Close()
+}
+
+// QueryNames to get the names of the queries of a sharing export
+func (s ShareExport) QueryNames() []string {
+	if s.Queries == "" {
+		return []string{}
+	}
+	return strings.Split(s.Queries, ",")
+}
+
+// RedactionRules to get the redaction of a sharing export
+func (s ShareExport) RedactionRules() ShareRedaction {
+	var r ShareRedaction
+	_ = json.Unmarshal([]byte(s.Redaction), &r)
+	return r
+}
+
+// Summary to describe the scope of a sharing export and who requested it, for the audit log
+func (s ShareExport) Summary() string {
+	return fmt.Sprintf("sharing export %s of queries %s requested by %s as %s, %s", s.Name, strings.Join(s.QueryNames(), ", "), s.Creator, s.Format, s.RedactionRules().Summary())
+}
+
+// NewShare to record a sharing export pending to be generated, the request is validated
+func (q *Queries) NewShare(req *ShareRequest) (ShareExport, error) {
+	if err := req.Validate(); err != nil {
+		return ShareExport{}, err
+	}
+	redaction, err := json.Marshal(req.Redaction)
+	if err != nil {
+		return ShareExport{}, fmt.Errorf("error serializing redaction %v", err)
+	}
+	share := ShareExport{
+		Name:          GenShareName(),
+		EnvironmentID: req.EnvironmentID,
+		Environment:   req.Environment,
+		Queries:       strings.Join(req.Queries, ","),
+		Format:        req.Format,
+		Redaction:     string(redaction),
+		Status:        SharePending,
+		Creator:       req.Creator,
+	}
+	if err := q.DB.Create(&share).Error; err != nil {
+		return share, fmt.Errorf("Create %v", err)
+	}
+	return share, nil
+}
+
+// GenerateShare to write the bundle of a pending sharing export and store it, the export is marked as failed on errors
+func (q *Queries) GenerateShare(ctx context.Context, share ShareExport, src ShareSource) (ShareManifest, error) {
+	req := ShareRequest{
+		EnvironmentID: share.EnvironmentID,
+		Environment:   share.Environment,
+		Queries:       share.QueryNames(),
+		Format:        share.Format,
+		Redaction:     share.RedactionRules(),
+		Creator:       share.Creator,
+	}
+	var buf bytes.Buffer
+	manifest, err := WriteShareBundle(&buf, share.Name, req, src)
+	if err != nil {
+		if uErr := q.DB.WithContext(ctx).Model(&share).Updates(map[string]interface{}{"status": ShareFailed, "error": err.Error()}).Error; uErr != nil {
+			return manifest, fmt.Errorf("Updates %v", uErr)
+		}
+		return manifest, err
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	if err := q.DB.WithContext(ctx).Model(&share).Updates(map[string]interface{}{
+		"status":  ShareReady,
+		"content": buf.Bytes(),
+		"size":    int64(buf.Len()),
+		"sha256":  hex.EncodeToString(sum[:]),
+	}).Error; err != nil {
+		return manifest, fmt.Errorf("Updates %v", err)
+	}
+	return manifest, nil
+}
+
+// Shares to get the sharing exports of an environment without their content, newest first
+func (q *Queries) Shares(envid uint) ([]ShareExport, error) {
+	var shares []ShareExport
+	if err := q.read().Omit("content").Where("environment_id = ?", envid).Order("created_at DESC").Find(&shares).Error; err != nil {
+		return shares, err
+	}
+	return shares, nil
+}
+
+// GetShare to get a sharing export by name, including its content once ready
+func (q *Queries) GetShare(name string, envid uint) (ShareExport, error) {
+	var share ShareExport
+	if err := q.DB.Where("name = ? AND environment_id = ?", name, envid).First(&share).Error; err != nil {
+		return share, err
+	}
+	return share, nil
+}
@@ -0,0 +1,164 @@
+package queries
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper to prepare a source of sharing exports in memory
+func memoryShareSource(results map[string][]map[string]string) ShareSource {
+	collected := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	return ShareSource{
+		Query: func(name string) (DistributedQuery, error) {
+			if name == "carve_1" {
+				return DistributedQuery{Name: name, Type: CarveQueryType}, nil
+			}
+			if _, ok := results[name]; !ok {
+				return DistributedQuery{}, fmt.Errorf("record not found")
+			}
+			return DistributedQuery{Name: name, Query: "SELECT * FROM logged_in_users;", Type: StandardQueryType, Creator: "responder"}, nil
+		},
+		Rows: func(name string, fn func(node string, collected time.Time, row map[string]string) error) error {
+			for i, row := range results[name] {
+				node := "AAAA-1111"
+				if i%2 == 1 {
+					node = "bbbb-2222"
+				}
+				if err := fn(node, collected, row); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Node: func(uuid string) (nodes.OsqueryNode, error) {
+			if uuid == "AAAA-1111" {
+				return nodes.OsqueryNode{UUID: uuid, Hostname: "laptop-1", Platform: "darwin", IPAddress: "10.0.0.5", Username: "alice"}, nil
+			}
+			return nodes.OsqueryNode{}, fmt.Errorf("record not found")
+		},
+	}
+}
+
+// Helper to read all the files of a bundle
+func readBundle(t *testing.T, data []byte) map[string][]byte {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	assert.NoError(t, err)
+	files := make(map[string][]byte)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		assert.NoError(t, err)
+		content, err := io.ReadAll(rc)
+		assert.NoError(t, err)
+		rc.Close()
+		files[f.Name] = content
+	}
+	return files
+}
+
+func TestShareRedaction(t *testing.T) {
+	r := ShareRedaction{DropIPs: true, DenyColumns: DefaultShareDenyColumns}
+	row := map[string]string{
+		"username":    "alice",
+		"LOGIN_NAME":  "alice",
+		"host":        "10.0.0.5",
+		"remote":      "[2001:db8::1]:443",
+		"subnet":      "192.168.0.0/16",
+		"path":        "/usr/bin/ssh",
+		"version":     "1.2.3.4.5",
+		"remote_user": "bob",
+	}
+	redacted := r.Row(row)
+	assert.Equal(t, map[string]string{
+		"host":    ShareRedacted,
+		"remote":  ShareRedacted,
+		"subnet":  ShareRedacted,
+		"path":    "/usr/bin/ssh",
+		"version": "1.2.3.4.5",
+	}, redacted)
+	// The original row is not modified
+	assert.Equal(t, "alice", row["username"])
+	assert.Equal(t, row, ShareRedaction{}.Row(row))
+	assert.Equal(t, "no redaction", ShareRedaction{}.Summary())
+}
+
+func TestShareRequestValidate(t *testing.T) {
+	req := ShareRequest{Queries: []string{"query_1"}}
+	assert.NoError(t, req.Validate())
+	assert.Equal(t, ExportJSON, req.Format)
+	assert.Equal(t, DefaultShareDenyColumns, req.Redaction.DenyColumns)
+	// An empty deny-list is kept
+	req = ShareRequest{Queries: []string{"query_1"}, Format: ExportCSV, Redaction: ShareRedaction{DenyColumns: []string{}}}
+	assert.NoError(t, req.Validate())
+	assert.Equal(t, 0, len(req.Redaction.DenyColumns))
+	for _, invalid := range []ShareRequest{
+		{},
+		{Queries: []string{"query_1", "query_1"}},
+		{Queries: []string{"query_1"}, Format: ExportParquet},
+		{Queries: []string{"query_1"}, Redaction: ShareRedaction{DenyColumns: []string{"[user"}}},
+	} {
+		assert.Error(t, invalid.Validate())
+	}
+}
+
+func TestWriteShareBundle(t *testing.T) {
+	src := memoryShareSource(map[string][]map[string]string{
+		"query_1": {
+			{"user": "alice", "host": "10.0.0.5", "tty": "ttys000"},
+			{"user": "bob", "host": "10.0.0.6", "tty": "ttys001"},
+		},
+		"query_2": {},
+	})
+	req := ShareRequest{Environment: "corp", Queries: []string{"query_1", "query_2"}, Format: ExportCSV, Redaction: ShareRedaction{DropIPs: true}, Creator: "admin"}
+	assert.NoError(t, req.Validate())
+	var buf bytes.Buffer
+	manifest, err := WriteShareBundle(&buf, "share_1", req, src)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, manifest.Nodes)
+	assert.Equal(t, 2, manifest.Rows)
+	files := readBundle(t, buf.Bytes())
+	assert.Equal(t, 6, len(files))
+	// Every file but the manifest is listed with its hash
+	var stored ShareManifest
+	assert.NoError(t, json.Unmarshal(files[ShareManifestFile], &stored))
+	assert.Equal(t, 5, len(stored.Files))
+	for _, f := range stored.Files {
+		sum := sha256.Sum256(files[f.Name])
+		assert.Equal(t, hex.EncodeToString(sum[:]), f.SHA256, f.Name)
+		assert.Equal(t, int64(len(files[f.Name])), f.Size, f.Name)
+	}
+	assert.Equal(t, "admin", stored.Creator)
+	results := string(files["queries/query_1/results.csv"])
+	assert.Equal(t, "node_uuid,collected_at,host,tty", strings.SplitN(results, "\n", 2)[0])
+	assert.NotContains(t, results, "alice")
+	assert.NotContains(t, results, "10.0.0.5")
+	assert.Contains(t, results, ShareRedacted)
+	var definition ShareQuery
+	assert.NoError(t, json.Unmarshal(files["queries/query_1/query.json"], &definition))
+	assert.Equal(t, "SELECT * FROM logged_in_users;", definition.Query)
+	// The node manifest is minimal, unknown nodes only have their UUID
+	var shared []ShareNode
+	assert.NoError(t, json.Unmarshal(files[ShareNodesFile], &shared))
+	assert.Equal(t, []ShareNode{
+		{UUID: "AAAA-1111", Hostname: "laptop-1", Platform: "darwin", Environment: "corp"},
+		{UUID: "BBBB-2222", Environment: "corp"},
+	}, shared)
+	assert.NotContains(t, string(files[ShareNodesFile]), "10.0.0.5")
+	// Carves and unknown queries can not be shared
+	req.Queries = []string{"carve_1"}
+	_, err = WriteShareBundle(&bytes.Buffer{}, "share_2", req, src)
+	assert.Error(t, err)
+	req.Queries = []string{"query_3"}
+	_, err = WriteShareBundle(&bytes.Buffer{}, "share_3", req, src)
+	assert.Error(t, err)
+}
@@ -0,0 +1,326 @@
+package queries
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Kinds of tokens of SQL
+const (
+	sqlIdent = iota
+	sqlPunct
+	sqlString
+	sqlNumber
+)
+
+// sqlToken to hold one token of SQL, identifiers are lowercase and quoted ones are not taken as keywords
+type sqlToken struct {
+	Kind   int
+	Text   string
+	Quoted bool
+}
+
+// SQLRefs to hold the tables and columns referenced by a query, with subqueries, joins and CTEs
+type SQLRefs struct {
+	Tables  []string
+	Columns []string
+	// Star is set when the query selects all the columns of a table
+	Star bool
+}
+
+// Keywords that end the list of tables of a FROM clause
+var sqlFromEnd = map[string]bool{
+	"where":     true,
+	"group":     true,
+	"order":     true,
+	"limit":     true,
+	"having":    true,
+	"union":     true,
+	"intersect": true,
+	"except":    true,
+	"window":    true,
+	"select":    true,
+	"values":    true,
+	"with":      true,
+}
+
+// Keywords that are not taken as columns
+var sqlKeywords = map[string]bool{
+	"select": true, "from": true, "where": true, "and": true, "or": true, "not": true, "as": true, "on": true,
+	"using": true, "join": true, "inner": true, "left": true, "right": true, "full": true, "outer": true,
+	"cross": true, "natural": true, "group": true, "by": true, "order": true, "asc": true, "desc": true,
+	"limit": true, "offset": true, "having": true, "union": true, "all": true, "intersect": true, "except": true,
+	"distinct": true, "with": true, "recursive": true, "in": true, "is": true, "null": true, "like": true,
+	"glob": true, "regexp": true, "match": true, "between": true, "case": true, "when": true, "then": true,
+	"else": true, "end": true, "exists": true, "cast": true, "collate": true, "escape": true, "window": true,
+	"over": true, "partition": true, "values": true, "true": true, "false": true, "materialized": true,
+}
+
+// Helper to split SQL in tokens, skipping comments
+func tokenizeSQL(sql string) ([]sqlToken, error) {
+	var tokens []sqlToken
+	isIdent := func(c byte) bool {
+		return c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c >= 0x80
+	}
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			i++
+		case c == '-' && i+1 < len(sql) && sql[i+1] == '-':
+			end := strings.IndexByte(sql[i:], '\n')
+			if end < 0 {
+				i = len(sql)
+			} else {
+				i += end + 1
+			}
+		case c == '/' && i+1 < len(sql) && sql[i+1] == '*':
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated comment")
+			}
+			i += end + 4
+		case c == '\'' || c == '"' || c == '`' || c == '[':
+			closing := c
+			if c == '[' {
+				closing = ']'
+			}
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(sql); j++ {
+				if sql[j] == closing {
+					// Quotes are escaped by doubling them
+					if closing != ']' && j+1 < len(sql) && sql[j+1] == closing {
+						b.WriteByte(closing)
+						j++
+						continue
+					}
+					break
+				}
+				b.WriteByte(sql[j])
+			}
+			if j >= len(sql) {
+				return nil, fmt.Errorf("unterminated quote %c", c)
+			}
+			if c == '\'' {
+				tokens = append(tokens, sqlToken{Kind: sqlString, Text: b.String()})
+			} else {
+				tokens = append(tokens, sqlToken{Kind: sqlIdent, Text: strings.ToLower(b.String()), Quoted: true})
+			}
+			i = j + 1
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(sql) && (isIdent(sql[j]) || sql[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, sqlToken{Kind: sqlNumber, Text: sql[i:j]})
+			i = j
+		case isIdent(c):
+			j := i
+			for j < len(sql) && isIdent(sql[j]) {
+				j++
+			}
+			tokens = append(tokens, sqlToken{Kind: sqlIdent, Text: strings.ToLower(sql[i:j])})
+			i = j
+		default:
+			tokens = append(tokens, sqlToken{Kind: sqlPunct, Text: string(c)})
+			i++
+		}
+	}
+	return tokens, nil
+}
+
+// Helper to check if a token is a keyword
+func (t sqlToken) keyword(words ...string) bool {
+	if t.Kind != sqlIdent || t.Quoted {
+		return false
+	}
+	for _, w := range words {
+		if t.Text == w {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper to check if a token is a punctuation character
+func (t sqlToken) punct(p string) bool {
+	return t.Kind == sqlPunct && t.Text == p
+}
+
+// Helper to find the index of the parenthesis closing the one at start
+func closingParen(tokens []sqlToken, start int) int {
+	depth := 0
+	for i := start; i < len(tokens); i++ {
+		if tokens[i].punct("(") {
+			depth++
+		} else if tokens[i].punct(")") {
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// sqlCTE to hold the name of a common table expression and the tokens where it can be referenced
+type sqlCTE struct {
+	Name  string
+	Start int
+	End   int
+}
+
+// Helper to find where the statement of a WITH clause ends, at the parenthesis closing it or at the end of the
+// statement
+func statementEnd(tokens []sqlToken, start int) int {
+	depth := 0
+	for i := start; i < len(tokens); i++ {
+		switch {
+		case tokens[i].punct("("):
+			depth++
+		case tokens[i].punct(")"):
+			if depth == 0 {
+				return i
+			}
+			depth--
+		case tokens[i].punct(";") && depth == 0:
+			return i
+		}
+	}
+	return len(tokens)
+}
+
+// Helper to get the names defined by the common table expressions of WITH clauses, each one only in the statement
+// of its own WITH clause, so a CTE in a subquery does not hide a table with the same name elsewhere
+func cteNames(tokens []sqlToken) []sqlCTE {
+	var ctes []sqlCTE
+	for i := 0; i < len(tokens); i++ {
+		if !tokens[i].keyword("with") {
+			continue
+		}
+		end := statementEnd(tokens, i+1)
+		j := i + 1
+		if j < len(tokens) && tokens[j].keyword("recursive") {
+			j++
+		}
+		for j < len(tokens) && tokens[j].Kind == sqlIdent {
+			name := tokens[j].Text
+			j++
+			if j < len(tokens) && tokens[j].punct("(") {
+				if j = closingParen(tokens, j); j < 0 {
+					return ctes
+				}
+				j++
+			}
+			if j >= len(tokens) || !tokens[j].keyword("as") {
+				break
+			}
+			j++
+			for j < len(tokens) && tokens[j].keyword("not", "materialized") {
+				j++
+			}
+			if j >= len(tokens) || !tokens[j].punct("(") {
+				break
+			}
+			ctes = append(ctes, sqlCTE{Name: name, Start: i, End: end})
+			if j = closingParen(tokens, j); j < 0 {
+				return ctes
+			}
+			j++
+			if j >= len(tokens) || !tokens[j].punct(",") {
+				break
+			}
+			j++
+		}
+	}
+	return ctes
+}
+
+// Helper to check if a name at a token is a common table expression and not a table
+func isCTE(ctes []sqlCTE, name string, i int) bool {
+	for _, c := range ctes {
+		if c.Name == name && i >= c.Start && i < c.End {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseSQLRefs to get the tables and columns referenced by a query, following subqueries, joins, comma joins
+// and common table expressions, whose names are not tables. Columns are every identifier that is not a table
+// or a keyword, so they may include aliases and functions.
+func ParseSQLRefs(sql string) (SQLRefs, error) {
+	var refs SQLRefs
+	tokens, err := tokenizeSQL(sql)
+	if err != nil {
+		return refs, err
+	}
+	ctes := cteNames(tokens)
+	tables := make(map[string]bool)
+	columns := make(map[string]bool)
+	// FROM clauses by depth of parenthesis, so commas after subqueries are joins too
+	inFrom := map[int]bool{}
+	depth := 0
+	expectTable := false
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		switch {
+		case t.punct("("):
+			// Parenthesis in a list of tables hold tables, joins or subqueries, like FROM (shell_history)
+			depth++
+			inFrom[depth] = expectTable
+		case t.punct(")"):
+			if depth == 0 {
+				return refs, fmt.Errorf("unbalanced parenthesis")
+			}
+			inFrom[depth] = false
+			depth--
+			expectTable = false
+		case t.punct(","):
+			expectTable = inFrom[depth]
+		case t.punct("*"):
+			if i > 0 && (tokens[i-1].keyword("select", "distinct", "all") || tokens[i-1].punct(",") || tokens[i-1].punct(".")) {
+				refs.Star = true
+			}
+		case t.keyword("from", "join"):
+			inFrom[depth] = true
+			expectTable = true
+		case t.keyword("on", "using"):
+			expectTable = false
+		case t.Kind == sqlIdent && !t.Quoted && sqlFromEnd[t.Text]:
+			inFrom[depth] = false
+			expectTable = false
+		case t.Kind == sqlIdent && expectTable:
+			name := t.Text
+			// Tables of a schema, like main.processes
+			if i+2 < len(tokens) && tokens[i+1].punct(".") && tokens[i+2].Kind == sqlIdent {
+				name = tokens[i+2].Text
+				i += 2
+			}
+			if !isCTE(ctes, name, i) {
+				tables[name] = true
+			}
+			expectTable = false
+		case t.Kind == sqlIdent:
+			if (t.Quoted || !sqlKeywords[t.Text]) && !isCTE(ctes, t.Text, i) {
+				columns[t.Text] = true
+			}
+		}
+	}
+	if depth != 0 {
+		return refs, fmt.Errorf("unbalanced parenthesis")
+	}
+	for t := range tables {
+		refs.Tables = append(refs.Tables, t)
+	}
+	for c := range columns {
+		if !tables[c] {
+			refs.Columns = append(refs.Columns, c)
+		}
+	}
+	sort.Strings(refs.Tables)
+	sort.Strings(refs.Columns)
+	return refs, nil
+}
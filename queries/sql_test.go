@@ -0,0 +1,74 @@
+package queries
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func sqlTables(t *testing.T, sql string) []string {
+	refs, err := ParseSQLRefs(sql)
+	assert.NoError(t, err)
+	return refs.Tables
+}
+
+func TestParseSQLRefsTables(t *testing.T) {
+	assert.Equal(t, []string{"processes"}, sqlTables(t, "SELECT pid, name FROM processes;"))
+	assert.Equal(t, []string{"processes", "users"}, sqlTables(t, "SELECT * FROM processes p JOIN users u ON p.uid = u.uid;"))
+	assert.Equal(t, []string{"processes", "users"}, sqlTables(t, "SELECT * FROM processes p LEFT OUTER JOIN users AS u USING (uid);"))
+	assert.Equal(t, []string{"processes", "users"}, sqlTables(t, "select * from users, processes where users.uid = processes.uid"))
+	assert.Equal(t, []string{"processes"}, sqlTables(t, "SELECT * FROM main.processes;"))
+	assert.Empty(t, sqlTables(t, "SELECT 1;"))
+}
+
+func TestParseSQLRefsObfuscation(t *testing.T) {
+	assert.Equal(t, []string{"shell_history"}, sqlTables(t, "SELECT * FROM (SELECT * FROM shell_history)"))
+	assert.Equal(t, []string{"shell_history"}, sqlTables(t, "SELECT * FROM (SELECT * FROM (SELECT command FROM shell_history) AS s)"))
+	assert.Equal(t, []string{"shell_history", "users"}, sqlTables(t, "SELECT * FROM users WHERE uid IN (SELECT uid FROM shell_history);"))
+	assert.Equal(t, []string{"shell_history", "users"}, sqlTables(t, "SELECT (SELECT count(*) FROM shell_history) AS c FROM users;"))
+	assert.Equal(t, []string{"shell_history", "users"}, sqlTables(t, "SELECT * FROM (SELECT 1) AS a, users, (SELECT * FROM shell_history) b;"))
+	assert.Equal(t, []string{"shell_history"}, sqlTables(t, `SELECT * FROM "Shell_History";`))
+	assert.Equal(t, []string{"shell_history"}, sqlTables(t, "SELECT * FROM [shell_history];"))
+	assert.Equal(t, []string{"shell_history"}, sqlTables(t, "SELECT * FROM `shell_history`;"))
+	assert.Equal(t, []string{"shell_history"}, sqlTables(t, "SELECT * FROM/**/shell_history -- FROM processes"))
+	assert.Equal(t, []string{"processes", "shell_history"}, sqlTables(t, "SELECT * FROM processes UNION SELECT * FROM shell_history;"))
+	assert.Equal(t, []string{"processes"}, sqlTables(t, "SELECT 'FROM shell_history' AS s FROM processes;"))
+	assert.Equal(t, []string{"shell_history"}, sqlTables(t, "SELECT * FROM (shell_history)"))
+	assert.Equal(t, []string{"processes", "shell_history"}, sqlTables(t, "SELECT * FROM processes NATURAL JOIN (shell_history)"))
+	assert.Equal(t, []string{"processes", "shell_history", "users"}, sqlTables(t, "SELECT * FROM ((processes) JOIN (users, shell_history))"))
+}
+
+func TestParseSQLRefsCTE(t *testing.T) {
+	assert.Equal(t, []string{"shell_history"}, sqlTables(t, "WITH h AS (SELECT * FROM shell_history) SELECT * FROM h;"))
+	assert.Equal(t, []string{"processes", "shell_history"}, sqlTables(t, "WITH RECURSIVE a(x) AS (SELECT * FROM processes), b AS (SELECT * FROM shell_history) SELECT * FROM a JOIN b;"))
+	// Names of CTEs only hide tables in the statement of their WITH clause
+	assert.Equal(t, []string{"shell_history"}, sqlTables(t, "SELECT * FROM shell_history WHERE 0 IN (WITH shell_history AS (SELECT 1) SELECT 1)"))
+	assert.Equal(t, []string{"processes"}, sqlTables(t, "SELECT * FROM (WITH p AS (SELECT * FROM processes) SELECT * FROM p)"))
+	assert.Equal(t, []string{"p"}, sqlTables(t, "SELECT * FROM (WITH p AS (SELECT 1) SELECT * FROM p), p"))
+}
+
+func TestParseSQLRefsColumns(t *testing.T) {
+	refs, err := ParseSQLRefs("SELECT u.username, count(*) AS total FROM users u GROUP BY u.username;")
+	assert.NoError(t, err)
+	assert.False(t, refs.Star)
+	assert.Contains(t, refs.Columns, "username")
+	assert.NotContains(t, refs.Columns, "users")
+	assert.NotContains(t, refs.Columns, "select")
+	refs, err = ParseSQLRefs("SELECT u.* FROM users u;")
+	assert.NoError(t, err)
+	assert.True(t, refs.Star)
+	refs, err = ParseSQLRefs("SELECT uid * 2 FROM users;")
+	assert.NoError(t, err)
+	assert.False(t, refs.Star)
+}
+
+func TestParseSQLRefsErrors(t *testing.T) {
+	_, err := ParseSQLRefs("SELECT * FROM (SELECT * FROM shell_history")
+	assert.Error(t, err)
+	_, err = ParseSQLRefs("SELECT * FROM processes)")
+	assert.Error(t, err)
+	_, err = ParseSQLRefs("SELECT 'x FROM shell_history")
+	assert.Error(t, err)
+	_, err = ParseSQLRefs("SELECT * FROM processes /* shell_history")
+	assert.Error(t, err)
+}
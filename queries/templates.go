@@ -0,0 +1,408 @@
+package queries
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jmpsec/osctrl/backend"
+	"gorm.io/gorm"
+)
+
+const (
+	// TemplateVarString for variables rendered as quoted SQL strings
+	TemplateVarString string = "string"
+	// TemplateVarInt for variables rendered as integers
+	TemplateVarInt string = "int"
+	// TemplateVarEnum for variables restricted to a list of values, rendered as quoted SQL strings
+	TemplateVarEnum string = "enum"
+)
+
+const (
+	// TemplateRiskLow for templates that are cheap and safe to run anywhere
+	TemplateRiskLow string = "low"
+	// TemplateRiskMedium for templates that need some care with the targets
+	TemplateRiskMedium string = "medium"
+	// TemplateRiskHigh for templates that are expensive or intrusive
+	TemplateRiskHigh string = "high"
+)
+
+// TemplateRisks to list all the valid risk levels of templates
+var TemplateRisks = []string{TemplateRiskLow, TemplateRiskMedium, TemplateRiskHigh}
+
+// Placeholders of variables in the SQL of templates, as {{name}}
+var templatePlaceholder = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+// Valid names of variables
+var templateVarName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// TemplateVariable to describe one variable of a query template
+type TemplateVariable struct {
+	Name   string   `json:"name"`
+	Type   string   `json:"type"`
+	Values []string `json:"values,omitempty"`
+}
+
+// QueryTemplate as abstraction of a vetted query shared across environments. Only admins modify templates,
+// users with query access run them with values for the variables. Variables are stored as JSON and
+// platforms as a comma separated list. Approved templates run even if they use tables restricted by query policies.
+type QueryTemplate struct {
+	gorm.Model
+	Name        string `gorm:"not null;unique;index"`
+	Description string
+	Query       string
+	Variables   string
+	Platforms   string
+	Risk        string
+	Team        string
+	Approved    bool
+	Version     int
+	Creator     string
+	DeletedBy   string
+}
+
+// QueryTemplateVersion to keep every version of a template with its changelog. Versions are kept when the
+// template is deleted, so queries launched from it still reference what they ran.
+type QueryTemplateVersion struct {
+	gorm.Model
+	Name        string `gorm:"index"`
+	Version     int
+	Description string
+	Query       string
+	Variables   string
+	Platforms   string
+	Risk        string
+	Team        string
+	Approved    bool
+	Author      string
+	Changelog   string
+}
+
+// Vars to get the variables of a template
+func (t QueryTemplate) Vars() ([]TemplateVariable, error) {
+	var vars []TemplateVariable
+	if t.Variables == "" {
+		return vars, nil
+	}
+	if err := json.Unmarshal([]byte(t.Variables), &vars); err != nil {
+		return vars, fmt.Errorf("invalid variables %v", err)
+	}
+	return vars, nil
+}
+
+// PlatformList to get the supported platforms of a template, empty for all platforms
+func (t QueryTemplate) PlatformList() []string {
+	var platforms []string
+	for _, p := range strings.Split(t.Platforms, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			platforms = append(platforms, p)
+		}
+	}
+	return platforms
+}
+
+// SupportsPlatform to check if a template supports a platform
+func (t QueryTemplate) SupportsPlatform(platform string) bool {
+	platforms := t.PlatformList()
+	if len(platforms) == 0 {
+		return true
+	}
+	for _, p := range platforms {
+		if p == platform {
+			return true
+		}
+	}
+	return false
+}
+
+// EncodeTemplateVariables to serialize the variables of a template
+func EncodeTemplateVariables(vars []TemplateVariable) string {
+	if len(vars) == 0 {
+		return ""
+	}
+	b, err := json.Marshal(vars)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// ParseTemplateVariable to parse a variable from name:type, with the values of enums as name:enum:a,b,c
+func ParseTemplateVariable(spec string) (TemplateVariable, error) {
+	parts := strings.SplitN(strings.TrimSpace(spec), ":", 3)
+	v := TemplateVariable{Name: strings.TrimSpace(parts[0]), Type: TemplateVarString}
+	if len(parts) > 1 {
+		v.Type = strings.TrimSpace(parts[1])
+	}
+	if len(parts) > 2 {
+		for _, value := range strings.Split(parts[2], ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				v.Values = append(v.Values, value)
+			}
+		}
+	}
+	return v, validTemplateVariable(v)
+}
+
+// Helper to validate one variable of a template
+func validTemplateVariable(v TemplateVariable) error {
+	if !templateVarName.MatchString(v.Name) {
+		return fmt.Errorf("invalid variable name %q", v.Name)
+	}
+	switch v.Type {
+	case TemplateVarString, TemplateVarInt:
+		if len(v.Values) > 0 {
+			return fmt.Errorf("variable %s of type %s can not have values", v.Name, v.Type)
+		}
+	case TemplateVarEnum:
+		if len(v.Values) == 0 {
+			return fmt.Errorf("variable %s of type enum needs values", v.Name)
+		}
+	default:
+		return fmt.Errorf("invalid type %q for variable %s", v.Type, v.Name)
+	}
+	return nil
+}
+
+// ValidateTemplate to check that a template is complete and that its variables match the placeholders of the SQL
+func ValidateTemplate(t QueryTemplate) error {
+	if strings.TrimSpace(t.Name) == "" {
+		return fmt.Errorf("template name can not be empty")
+	}
+	if strings.TrimSpace(t.Query) == "" {
+		return fmt.Errorf("template query can not be empty")
+	}
+	validRisk := false
+	for _, r := range TemplateRisks {
+		if t.Risk == r {
+			validRisk = true
+		}
+	}
+	if !validRisk {
+		return fmt.Errorf("invalid risk %q, valid risks are %s", t.Risk, strings.Join(TemplateRisks, ", "))
+	}
+	vars, err := t.Vars()
+	if err != nil {
+		return err
+	}
+	declared := make(map[string]bool)
+	for _, v := range vars {
+		if err := validTemplateVariable(v); err != nil {
+			return err
+		}
+		if declared[v.Name] {
+			return fmt.Errorf("variable %s is declared twice", v.Name)
+		}
+		declared[v.Name] = true
+	}
+	used := make(map[string]bool)
+	for _, m := range templatePlaceholder.FindAllStringSubmatch(t.Query, -1) {
+		if !declared[m[1]] {
+			return fmt.Errorf("variable %s is used but not declared", m[1])
+		}
+		used[m[1]] = true
+	}
+	for _, v := range vars {
+		if !used[v.Name] {
+			return fmt.Errorf("variable %s is declared but not used", v.Name)
+		}
+	}
+	return nil
+}
+
+// Helper to quote a value as a SQL string
+func quoteSQL(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// RenderTemplate to get the SQL of a template with the values of its variables, all of them are required
+// and validated by type. Strings and enums are rendered quoted, so placeholders go without quotes in the SQL.
+func RenderTemplate(t QueryTemplate, values map[string]string) (string, error) {
+	vars, err := t.Vars()
+	if err != nil {
+		return "", err
+	}
+	rendered := make(map[string]string, len(vars))
+	for _, v := range vars {
+		value, ok := values[v.Name]
+		if !ok || value == "" {
+			return "", fmt.Errorf("missing value for variable %s", v.Name)
+		}
+		switch v.Type {
+		case TemplateVarInt:
+			n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+			if err != nil {
+				return "", fmt.Errorf("value for variable %s must be an integer", v.Name)
+			}
+			rendered[v.Name] = strconv.FormatInt(n, 10)
+		case TemplateVarEnum:
+			valid := false
+			for _, allowed := range v.Values {
+				if value == allowed {
+					valid = true
+				}
+			}
+			if !valid {
+				return "", fmt.Errorf("value for variable %s must be one of %s", v.Name, strings.Join(v.Values, ", "))
+			}
+			rendered[v.Name] = quoteSQL(value)
+		default:
+			if strings.ContainsRune(value, 0) {
+				return "", fmt.Errorf("invalid value for variable %s", v.Name)
+			}
+			rendered[v.Name] = quoteSQL(value)
+		}
+	}
+	var unknown []string
+	for name := range values {
+		if _, ok := rendered[name]; !ok {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return "", fmt.Errorf("unknown variables %s", strings.Join(unknown, ", "))
+	}
+	return templatePlaceholder.ReplaceAllStringFunc(t.Query, func(m string) string {
+		return rendered[templatePlaceholder.FindStringSubmatch(m)[1]]
+	}), nil
+}
+
+// ApplyTemplate to set the SQL of a new query from a template, recording the template and version it comes from
+func ApplyTemplate(query *DistributedQuery, t QueryTemplate, values map[string]string) error {
+	sql, err := RenderTemplate(t, values)
+	if err != nil {
+		return err
+	}
+	query.Query = sql
+	query.TemplateName = t.Name
+	query.TemplateVersion = t.Version
+	return nil
+}
+
+// Helper to prepare a version entry of a template
+func templateVersion(t QueryTemplate, author, changelog string) QueryTemplateVersion {
+	return QueryTemplateVersion{
+		Name:        t.Name,
+		Version:     t.Version,
+		Description: t.Description,
+		Query:       t.Query,
+		Variables:   t.Variables,
+		Platforms:   t.Platforms,
+		Risk:        t.Risk,
+		Team:        t.Team,
+		Approved:    t.Approved,
+		Author:      author,
+		Changelog:   changelog,
+	}
+}
+
+// GetTemplates to get all the query templates
+func (q *Queries) GetTemplates() ([]QueryTemplate, error) {
+	var templates []QueryTemplate
+	if err := q.read().Order("name").Find(&templates).Error; err != nil {
+		return templates, err
+	}
+	return templates, nil
+}
+
+// GetTemplate to get a query template by name
+func (q *Queries) GetTemplate(name string) (QueryTemplate, error) {
+	var t QueryTemplate
+	if err := q.read().Where("name = ?", name).First(&t).Error; err != nil {
+		return t, err
+	}
+	return t, nil
+}
+
+// GetTemplateVersions to get all the versions of a template by name, newest first. Versions of deleted templates are kept.
+func (q *Queries) GetTemplateVersions(name string) ([]QueryTemplateVersion, error) {
+	var versions []QueryTemplateVersion
+	if err := q.read().Where("name = ?", name).Order("version desc").Find(&versions).Error; err != nil {
+		return versions, err
+	}
+	return versions, nil
+}
+
+// CreateTemplate to create a new query template, as the version after any previous template with the same name
+func (q *Queries) CreateTemplate(t QueryTemplate, changelog string) (QueryTemplate, error) {
+	if err := ValidateTemplate(t); err != nil {
+		return t, err
+	}
+	if changelog == "" {
+		changelog = "created"
+	}
+	// Names are unique, also for templates in the trash
+	var trashed int64
+	if err := q.DB.Unscoped().Model(&QueryTemplate{}).Where("name = ? AND deleted_at IS NOT NULL", t.Name).Count(&trashed).Error; err != nil {
+		return t, fmt.Errorf("Count %v", err)
+	}
+	if trashed > 0 {
+		return t, fmt.Errorf("template %s is in the trash, restore it or delete it permanently", t.Name)
+	}
+	err := q.DB.Transaction(func(tx *gorm.DB) error {
+		var last int
+		if err := tx.Model(&QueryTemplateVersion{}).Where("name = ?", t.Name).Select("COALESCE(MAX(version), 0)").Scan(&last).Error; err != nil {
+			return fmt.Errorf("Scan %v", err)
+		}
+		t.Version = last + 1
+		if err := tx.Create(&t).Error; err != nil {
+			return fmt.Errorf("Create %v", err)
+		}
+		v := templateVersion(t, t.Creator, changelog)
+		if err := tx.Create(&v).Error; err != nil {
+			return fmt.Errorf("Create %v", err)
+		}
+		return nil
+	})
+	return t, err
+}
+
+// UpdateTemplate to update an existing query template, as a new version with a changelog
+func (q *Queries) UpdateTemplate(name string, t QueryTemplate, author, changelog string) (QueryTemplate, error) {
+	if strings.TrimSpace(changelog) == "" {
+		return t, fmt.Errorf("changelog can not be empty")
+	}
+	current, err := q.GetTemplate(name)
+	if err != nil {
+		return t, fmt.Errorf("error getting template %v", err)
+	}
+	t.Name = name
+	if err := ValidateTemplate(t); err != nil {
+		return t, err
+	}
+	current.Description = t.Description
+	current.Query = t.Query
+	current.Variables = t.Variables
+	current.Platforms = t.Platforms
+	current.Risk = t.Risk
+	current.Team = t.Team
+	current.Approved = t.Approved
+	current.Version++
+	err = q.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&current).Error; err != nil {
+			return fmt.Errorf("Save %v", err)
+		}
+		v := templateVersion(current, author, changelog)
+		if err := tx.Create(&v).Error; err != nil {
+			return fmt.Errorf("Create %v", err)
+		}
+		return nil
+	})
+	return current, err
+}
+
+// DeleteTemplate to move a query template to the trash, its versions and the queries launched from it are kept
+func (q *Queries) DeleteTemplate(name, user string) error {
+	t, err := q.GetTemplate(name)
+	if err != nil {
+		return fmt.Errorf("error getting template %v", err)
+	}
+	if err := backend.SoftDelete(q.DB, &t, user); err != nil {
+		return fmt.Errorf("DeleteTemplate %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package queries
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testTemplate() QueryTemplate {
+	return QueryTemplate{
+		Name:  "processes-by-user",
+		Query: "SELECT * FROM processes WHERE uid = {{uid}} AND name = {{ name }} AND state = {{state}};",
+		Variables: EncodeTemplateVariables([]TemplateVariable{
+			{Name: "uid", Type: TemplateVarInt},
+			{Name: "name", Type: TemplateVarString},
+			{Name: "state", Type: TemplateVarEnum, Values: []string{"R", "S"}},
+		}),
+		Platforms: "darwin, ubuntu",
+		Risk:      TemplateRiskLow,
+		Version:   3,
+	}
+}
+
+func TestParseTemplateVariable(t *testing.T) {
+	v, err := ParseTemplateVariable("state:enum:R, S")
+	assert.NoError(t, err)
+	assert.Equal(t, TemplateVariable{Name: "state", Type: TemplateVarEnum, Values: []string{"R", "S"}}, v)
+	v, err = ParseTemplateVariable("name")
+	assert.NoError(t, err)
+	assert.Equal(t, TemplateVarString, v.Type)
+	_, err = ParseTemplateVariable("state:enum")
+	assert.Error(t, err)
+	_, err = ParseTemplateVariable("uid:float")
+	assert.Error(t, err)
+	_, err = ParseTemplateVariable("1uid:int")
+	assert.Error(t, err)
+}
+
+func TestValidateTemplate(t *testing.T) {
+	assert.NoError(t, ValidateTemplate(testTemplate()))
+	tpl := testTemplate()
+	tpl.Query = "SELECT * FROM processes WHERE uid = {{uid}} AND name = {{name}};"
+	assert.EqualError(t, ValidateTemplate(tpl), "variable state is declared but not used")
+	tpl = testTemplate()
+	tpl.Query += " {{path}}"
+	assert.EqualError(t, ValidateTemplate(tpl), "variable path is used but not declared")
+	tpl = testTemplate()
+	tpl.Risk = "critical"
+	assert.Error(t, ValidateTemplate(tpl))
+}
+
+func TestRenderTemplate(t *testing.T) {
+	tpl := testTemplate()
+	sql, err := RenderTemplate(tpl, map[string]string{"uid": "501", "name": "o'brien", "state": "R"})
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT * FROM processes WHERE uid = 501 AND name = 'o''brien' AND state = 'R';", sql)
+	_, err = RenderTemplate(tpl, map[string]string{"uid": "501 OR 1=1", "name": "a", "state": "R"})
+	assert.EqualError(t, err, "value for variable uid must be an integer")
+	_, err = RenderTemplate(tpl, map[string]string{"uid": "501", "name": "a", "state": "Z"})
+	assert.EqualError(t, err, "value for variable state must be one of R, S")
+	_, err = RenderTemplate(tpl, map[string]string{"uid": "501", "state": "R"})
+	assert.EqualError(t, err, "missing value for variable name")
+	_, err = RenderTemplate(tpl, map[string]string{"uid": "501", "name": "a", "state": "R", "extra": "x"})
+	assert.EqualError(t, err, "unknown variables extra")
+}
+
+func TestApplyTemplate(t *testing.T) {
+	var q DistributedQuery
+	assert.NoError(t, ApplyTemplate(&q, testTemplate(), map[string]string{"uid": "0", "name": "launchd", "state": "S"}))
+	assert.Equal(t, "processes-by-user", q.TemplateName)
+	assert.Equal(t, 3, q.TemplateVersion)
+	assert.Contains(t, q.Query, "name = 'launchd'")
+}
+
+func TestTemplatePlatforms(t *testing.T) {
+	tpl := testTemplate()
+	assert.Equal(t, []string{"darwin", "ubuntu"}, tpl.PlatformList())
+	assert.True(t, tpl.SupportsPlatform("ubuntu"))
+	assert.False(t, tpl.SupportsPlatform("windows"))
+	tpl.Platforms = ""
+	assert.True(t, tpl.SupportsPlatform("windows"))
+}
@@ -0,0 +1,194 @@
+package queries
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jmpsec/osctrl/backend"
+	"gorm.io/gorm"
+)
+
+// Helper to get a query or carve in the trash by name
+func (q *Queries) getTrashed(name string, envid uint) (DistributedQuery, error) {
+	var query DistributedQuery
+	if err := q.DB.Unscoped().Where("name = ? AND environment_id = ? AND deleted_at IS NOT NULL", name, envid).First(&query).Error; err != nil {
+		return query, fmt.Errorf("query %s is not in the trash: %v", name, err)
+	}
+	return query, nil
+}
+
+// Helper to delete the rows kept for queries by name, when the queries are deleted permanently
+func deleteQueryData(tx *gorm.DB, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	for _, model := range []interface{}{&DistributedQueryTarget{}, &DistributedQueryExecution{}, &DistributedQueryLabel{}, &QueryProfileSample{}, &QueryProfileResult{}, &NodeQuery{}} {
+		if err := tx.Unscoped().Where("name IN ?", names).Delete(model).Error; err != nil {
+			return fmt.Errorf("Delete %v", err)
+		}
+	}
+	return deleteArchives(tx, names)
+}
+
+// Trash to get the queries or carves of an environment deleted after the cutoff, newest first
+func (q *Queries) Trash(qtype string, envid uint, cutoff time.Time) ([]DistributedQuery, error) {
+	var queries []DistributedQuery
+	if err := q.read().Scopes(backend.InTrash(cutoff)).Where("type = ? AND environment_id = ?", qtype, envid).Find(&queries).Error; err != nil {
+		return queries, err
+	}
+	return queries, nil
+}
+
+// Restore to take a query or carve out of the trash, it is restored as not active so nodes do not run it again
+func (q *Queries) Restore(name string, envid uint) error {
+	query, err := q.getTrashed(name, envid)
+	if err != nil {
+		return err
+	}
+	if err := q.DB.Unscoped().Model(&query).Update("deleted", false).Error; err != nil {
+		return fmt.Errorf("Update %v", err)
+	}
+	return backend.Restore(q.DB, &query)
+}
+
+// HardDelete to delete a query or carve permanently, in the trash or not, with all the rows kept for it
+func (q *Queries) HardDelete(name string, envid uint, kind, user string) error {
+	var query DistributedQuery
+	if err := q.DB.Unscoped().Where("name = ? AND environment_id = ?", name, envid).First(&query).Error; err != nil {
+		return fmt.Errorf("error getting query %v", err)
+	}
+	return q.DB.Transaction(func(tx *gorm.DB) error {
+		if err := deleteQueryData(tx, []string{name}); err != nil {
+			return err
+		}
+		return backend.HardDelete(tx, &query, kind, name, user)
+	})
+}
+
+// TrashSaved to get the saved queries of a creator deleted after the cutoff, newest first
+func (q *Queries) TrashSaved(creator string, envid uint, cutoff time.Time) ([]SavedQuery, error) {
+	var saved []SavedQuery
+	if err := q.read().Scopes(backend.InTrash(cutoff)).Where("creator = ? AND environment_id = ?", creator, envid).Find(&saved).Error; err != nil {
+		return saved, err
+	}
+	return saved, nil
+}
+
+// RestoreSaved to take the last deleted saved query of a creator out of the trash
+func (q *Queries) RestoreSaved(name, creator string, envid uint) error {
+	var saved SavedQuery
+	if err := q.DB.Unscoped().Where("name = ? AND creator = ? AND environment_id = ? AND deleted_at IS NOT NULL", name, creator, envid).Order("deleted_at DESC").First(&saved).Error; err != nil {
+		return fmt.Errorf("saved query %s is not in the trash: %v", name, err)
+	}
+	return backend.Restore(q.DB, &saved)
+}
+
+// HardDeleteSaved to delete a saved query of a creator permanently, in the trash or not
+func (q *Queries) HardDeleteSaved(name, creator string, envid uint) error {
+	var saved []SavedQuery
+	if err := q.DB.Unscoped().Where("name = ? AND creator = ? AND environment_id = ?", name, creator, envid).Find(&saved).Error; err != nil {
+		return fmt.Errorf("error getting saved query %v", err)
+	}
+	if len(saved) == 0 {
+		return fmt.Errorf("saved query %s not found", name)
+	}
+	for i := range saved {
+		if err := backend.HardDelete(q.DB, &saved[i], backend.TrashSaved, name, creator); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TrashTemplates to get the query templates deleted after the cutoff, newest first
+func (q *Queries) TrashTemplates(cutoff time.Time) ([]QueryTemplate, error) {
+	var templates []QueryTemplate
+	if err := q.read().Scopes(backend.InTrash(cutoff)).Find(&templates).Error; err != nil {
+		return templates, err
+	}
+	return templates, nil
+}
+
+// RestoreTemplate to take a query template out of the trash
+func (q *Queries) RestoreTemplate(name string) error {
+	var t QueryTemplate
+	if err := q.DB.Unscoped().Where("name = ? AND deleted_at IS NOT NULL", name).First(&t).Error; err != nil {
+		return fmt.Errorf("template %s is not in the trash: %v", name, err)
+	}
+	return backend.Restore(q.DB, &t)
+}
+
+// HardDeleteTemplate to delete a query template permanently, in the trash or not. Its versions are kept.
+func (q *Queries) HardDeleteTemplate(name, user string) error {
+	var t QueryTemplate
+	if err := q.DB.Unscoped().Where("name = ?", name).First(&t).Error; err != nil {
+		return fmt.Errorf("error getting template %v", err)
+	}
+	return backend.HardDelete(q.DB, &t, backend.TrashTemplates, name, user)
+}
+
+// PurgeTrash to delete permanently the queries, carves, saved queries and templates deleted before the cutoff,
+// except the carves of cases with an active hold
+func (q *Queries) PurgeTrash(cutoff time.Time) (int64, error) {
+	var purged int64
+	err := q.DB.Transaction(func(tx *gorm.DB) error {
+		// Carves of cases with an active hold are kept until the hold is stopped
+		var names []string
+		if err := tx.Model(&DistributedQuery{}).Scopes(backend.Expired(cutoff)).Where("case_id = '' OR case_id NOT IN (?)", heldCases(tx)).Pluck("name", &names).Error; err != nil {
+			return fmt.Errorf("Pluck %v", err)
+		}
+		if len(names) > 0 {
+			if err := deleteQueryData(tx, names); err != nil {
+				return err
+			}
+			res := tx.Unscoped().Where("name IN ?", names).Delete(&DistributedQuery{})
+			if res.Error != nil {
+				return fmt.Errorf("Delete %v", res.Error)
+			}
+			purged += res.RowsAffected
+		}
+		for _, model := range []interface{}{&SavedQuery{}, &QueryTemplate{}} {
+			res := tx.Scopes(backend.Expired(cutoff)).Delete(model)
+			if res.Error != nil {
+				return fmt.Errorf("Delete %v", res.Error)
+			}
+			purged += res.RowsAffected
+		}
+		return nil
+	})
+	return purged, err
+}
+
+// TrashItems to get the queries, carves and templates in the trash, with the saved queries of a creator
+func (q *Queries) TrashItems(envid uint, creator string, days int, now time.Time) ([]backend.TrashItem, error) {
+	items := []backend.TrashItem{}
+	cutoff := backend.TrashCutoff(days, now)
+	for _, qtype := range []string{StandardQueryType, CarveQueryType} {
+		kind := backend.TrashQueries
+		if qtype == CarveQueryType {
+			kind = backend.TrashCarves
+		}
+		trashed, err := q.Trash(qtype, envid, cutoff)
+		if err != nil {
+			return items, err
+		}
+		for _, t := range trashed {
+			items = append(items, backend.NewTrashItem(kind, t.Name, t.Query, t.DeletedBy, t.DeletedAt, days))
+		}
+	}
+	saved, err := q.TrashSaved(creator, envid, cutoff)
+	if err != nil {
+		return items, err
+	}
+	for _, s := range saved {
+		items = append(items, backend.NewTrashItem(backend.TrashSaved, s.Name, s.Query, s.DeletedBy, s.DeletedAt, days))
+	}
+	templates, err := q.TrashTemplates(cutoff)
+	if err != nil {
+		return items, err
+	}
+	for _, t := range templates {
+		items = append(items, backend.NewTrashItem(backend.TrashTemplates, t.Name, t.Description, t.DeletedBy, t.DeletedAt, days))
+	}
+	return items, nil
+}
@@ -6,3 +6,23 @@ import "github.com/jmpsec/osctrl/utils"
 func GenQueryName() string {
 	return "query_" + utils.RandomForNames()
 }
+
+// Helper to generate a random carve name
+func GenCarveName() string {
+	return "carve_" + utils.RandomForNames()
+}
+
+// Helper to generate a random recurring carve name
+func GenRecurringCarveName() string {
+	return "recurring_" + utils.RandomForNames()
+}
+
+// Helper to generate a random sharing export name
+func GenShareName() string {
+	return "share_" + utils.RandomForNames()
+}
+
+// Helper to generate a random export job ID
+func GenExportJobID() string {
+	return "export_" + utils.RandomForNames()
+}
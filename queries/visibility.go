@@ -0,0 +1,279 @@
+package queries
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// VisibilityPublic for results visible to every user with access to the environment
+	VisibilityPublic string = "public"
+	// VisibilityCreator for results visible only to the creator of the query
+	VisibilityCreator string = "creator"
+	// VisibilityTeam for results visible to the creator and the members of a team
+	VisibilityTeam string = "team"
+)
+
+const (
+	// ListingHide to leave restricted queries out of the lists of who can not see them
+	ListingHide string = "hide"
+	// ListingLock to list restricted queries without their SQL, context or results
+	ListingLock string = "lock"
+)
+
+// BreakGlassDuration is how long an admin can see the results of a restricted query after breaking the glass
+const BreakGlassDuration = time.Hour
+
+// Visibilities to list all the visibilities of queries
+var Visibilities = []string{VisibilityPublic, VisibilityCreator, VisibilityTeam}
+
+// Viewer to hold who is looking at queries, with the teams the results can be shared with
+type Viewer struct {
+	Username string
+	Teams    []string
+}
+
+// QueryAccessGrant to record an admin breaking the glass to see the results of a restricted query, the access
+// ends when it expires
+type QueryAccessGrant struct {
+	gorm.Model
+	Name          string `gorm:"index"`
+	EnvironmentID uint
+	Username      string `gorm:"index"`
+	Reason        string
+	ExpiresAt     time.Time
+}
+
+// ValidateVisibility to check a visibility, empty is public, and that the team is set only for team visibility
+func ValidateVisibility(visibility, team string) error {
+	switch visibility {
+	case "", VisibilityPublic, VisibilityCreator:
+		if team != "" {
+			return fmt.Errorf("team can only be used with %s visibility", VisibilityTeam)
+		}
+		return nil
+	case VisibilityTeam:
+		if strings.TrimSpace(team) == "" {
+			return fmt.Errorf("team visibility needs a team")
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid visibility %q, use %s", visibility, strings.Join(Visibilities, ", "))
+}
+
+// ValidListing to check how restricted queries are listed, empty hides them
+func ValidListing(listing string) bool {
+	return listing == "" || listing == ListingHide || listing == ListingLock
+}
+
+// Restricted to check if the results of a query are not visible to everyone
+func (q DistributedQuery) Restricted() bool {
+	return q.Visibility != "" && q.Visibility != VisibilityPublic
+}
+
+// VisibleTo to check if a viewer can see the query and its results, without access granted by breaking the glass
+func (q DistributedQuery) VisibleTo(v Viewer) bool {
+	if !q.Restricted() || q.Creator == v.Username {
+		return true
+	}
+	if q.Visibility == VisibilityTeam {
+		for _, t := range v.Teams {
+			if t == q.VisibilityTeam {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// VisibilitySummary to describe who can see the results of a query
+func (q DistributedQuery) VisibilitySummary() string {
+	switch q.Visibility {
+	case VisibilityCreator:
+		return "creator only"
+	case VisibilityTeam:
+		return "team " + q.VisibilityTeam
+	}
+	return VisibilityPublic
+}
+
+// Locked to get a query as it is listed to who can not see it, without SQL, path, context or targets
+func Locked(q DistributedQuery) DistributedQuery {
+	return DistributedQuery{
+		Model:         q.Model,
+		Name:          q.Name,
+		Creator:       q.Creator,
+		Expected:      q.Expected,
+		Executions:    q.Executions,
+		Errors:        q.Errors,
+		Active:        q.Active,
+		Hidden:        q.Hidden,
+		Completed:     q.Completed,
+		Deleted:       q.Deleted,
+		Type:          q.Type,
+		EnvironmentID: q.EnvironmentID,
+		ExpiresAt:     q.ExpiresAt,
+		Visibility:    q.Visibility,
+		Locked:        true,
+	}
+}
+
+// Helper to restrict a DB query to the queries a viewer can see, including the ones granted by breaking the glass
+func visibleScope(db *gorm.DB, v Viewer, now time.Time) *gorm.DB {
+	teams := v.Teams
+	if len(teams) == 0 {
+		// IN with an empty list is not valid SQL in every backend
+		teams = []string{""}
+	}
+	// Queries created before visibility existed have no value and they are public
+	granted := db.Session(&gorm.Session{NewDB: true}).Model(&QueryAccessGrant{}).Select("name").Where("username = ? AND expires_at > ?", v.Username, now)
+	return db.Where(
+		"(visibility IS NULL OR visibility IN ? OR creator = ? OR (visibility = ? AND visibility_team IN ?) OR name IN (?))",
+		[]string{"", VisibilityPublic},
+		v.Username,
+		VisibilityTeam,
+		teams,
+		granted,
+	)
+}
+
+// GetsVisibleCtx to get queries by target that a viewer can see, aborting if ctx is cancelled. The visibility is
+// checked by the DB, so restricted queries are never loaded.
+func (q *Queries) GetsVisibleCtx(ctx context.Context, target, qtype string, envid uint, v Viewer) ([]DistributedQuery, error) {
+	return q.getsWith(visibleScope(q.read().WithContext(ctx), v, time.Now()), target, qtype, envid)
+}
+
+// ListCtx to get queries by target as they are listed to a viewer, aborting if ctx is cancelled. Restricted queries
+// the viewer can not see are left out, or locked if the listing is lock.
+func (q *Queries) ListCtx(ctx context.Context, target, qtype string, envid uint, v Viewer, listing string) ([]DistributedQuery, error) {
+	if listing != ListingLock {
+		return q.GetsVisibleCtx(ctx, target, qtype, envid, v)
+	}
+	qs, err := q.GetsCtx(ctx, target, qtype, envid)
+	if err != nil {
+		return qs, err
+	}
+	return q.LockInvisible(ctx, qs, v)
+}
+
+// LockInvisible to lock the queries a viewer can not see, checking the access granted to all of them at once
+func (q *Queries) LockInvisible(ctx context.Context, qs []DistributedQuery, v Viewer) ([]DistributedQuery, error) {
+	var names []string
+	for _, query := range qs {
+		if !query.VisibleTo(v) {
+			names = append(names, query.Name)
+		}
+	}
+	if len(names) == 0 {
+		return qs, nil
+	}
+	var granted []string
+	if err := q.read().WithContext(ctx).Model(&QueryAccessGrant{}).Where("username = ? AND expires_at > ? AND name IN ?", v.Username, time.Now(), names).Pluck("name", &granted).Error; err != nil {
+		return qs, err
+	}
+	access := make(map[string]bool, len(granted))
+	for _, n := range granted {
+		access[n] = true
+	}
+	res := make([]DistributedQuery, 0, len(qs))
+	for _, query := range qs {
+		if !query.VisibleTo(v) && !access[query.Name] {
+			query = Locked(query)
+		}
+		res = append(res, query)
+	}
+	return res, nil
+}
+
+// HiddenNamesCtx to get the names of the queries and carves of an environment a viewer can not see, aborting if ctx
+// is cancelled. It is used to leave out what is linked to them by name, like carved files.
+func (q *Queries) HiddenNamesCtx(ctx context.Context, envid uint, v Viewer) (map[string]bool, error) {
+	var restricted []DistributedQuery
+	if err := q.read().WithContext(ctx).Select("id", "name", "creator", "visibility", "visibility_team", "environment_id").Where("environment_id = ? AND visibility IS NOT NULL AND visibility NOT IN ?", envid, []string{"", VisibilityPublic}).Find(&restricted).Error; err != nil {
+		return nil, err
+	}
+	locked, err := q.LockInvisible(ctx, restricted, v)
+	if err != nil {
+		return nil, err
+	}
+	hidden := make(map[string]bool)
+	for _, query := range locked {
+		if query.Locked {
+			hidden[query.Name] = true
+		}
+	}
+	return hidden, nil
+}
+
+// CanView to check if a viewer can see a query and its results, with the access granted by breaking the glass
+func (q *Queries) CanView(ctx context.Context, query DistributedQuery, v Viewer) (bool, error) {
+	if query.VisibleTo(v) {
+		return true, nil
+	}
+	var count int64
+	if err := q.DB.WithContext(ctx).Model(&QueryAccessGrant{}).Where("name = ? AND environment_id = ? AND username = ? AND expires_at > ?", query.Name, query.EnvironmentID, v.Username, time.Now()).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// CanViewName to check if a viewer can see the results of a query by name, in any environment if envid is 0.
+// Names that are not queries are visible.
+func (q *Queries) CanViewName(ctx context.Context, name string, envid uint, v Viewer) (bool, error) {
+	var query DistributedQuery
+	db := q.DB.WithContext(ctx).Where("name = ?", name)
+	if envid != 0 {
+		db = db.Where("environment_id = ?", envid)
+	}
+	if err := db.Find(&query).Error; err != nil {
+		return false, err
+	}
+	if query.ID == 0 {
+		return true, nil
+	}
+	return q.CanView(ctx, query, v)
+}
+
+// BreakGlass to grant an admin access to the results of a restricted query for a while, with the reason
+func (q *Queries) BreakGlass(query DistributedQuery, username, reason string) (QueryAccessGrant, error) {
+	grant := QueryAccessGrant{
+		Name:          query.Name,
+		EnvironmentID: query.EnvironmentID,
+		Username:      username,
+		Reason:        strings.TrimSpace(reason),
+		ExpiresAt:     time.Now().Add(BreakGlassDuration),
+	}
+	if grant.Reason == "" {
+		return grant, fmt.Errorf("reason is required to break the glass")
+	}
+	if err := q.DB.Create(&grant).Error; err != nil {
+		return grant, fmt.Errorf("Create %v", err)
+	}
+	return grant, nil
+}
+
+// SetVisibility to change who can see the results of a query, returning the query as it was before
+func (q *Queries) SetVisibility(name string, envid uint, visibility, team string) (DistributedQuery, error) {
+	team = strings.TrimSpace(team)
+	if err := ValidateVisibility(visibility, team); err != nil {
+		return DistributedQuery{}, err
+	}
+	query, err := q.Get(name, envid)
+	if err != nil {
+		return query, err
+	}
+	if query.ID == 0 {
+		return query, gorm.ErrRecordNotFound
+	}
+	if visibility == "" {
+		visibility = VisibilityPublic
+	}
+	if err := q.DB.Model(&query).Updates(map[string]interface{}{"visibility": visibility, "visibility_team": team}).Error; err != nil {
+		return query, fmt.Errorf("Updates %v", err)
+	}
+	return query, nil
+}
@@ -0,0 +1,126 @@
+package queries
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// Helper to get queries backed by a DB that only builds SQL, so restricted rows are never returned
+func dryRunQueries(t *testing.T) *Queries {
+	mockDB, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db, got error: %v", err)
+	}
+	t.Cleanup(func() { mockDB.Close() })
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: mockDB}), &gorm.Config{DryRun: true})
+	if err != nil {
+		t.Fatalf("unable to create new postgres database: %v", err)
+	}
+	return &Queries{DB: db}
+}
+
+func TestValidateVisibility(t *testing.T) {
+	assert.NoError(t, ValidateVisibility("", ""))
+	assert.NoError(t, ValidateVisibility(VisibilityPublic, ""))
+	assert.NoError(t, ValidateVisibility(VisibilityCreator, ""))
+	assert.NoError(t, ValidateVisibility(VisibilityTeam, "ir"))
+	assert.EqualError(t, ValidateVisibility(VisibilityTeam, " "), "team visibility needs a team")
+	assert.EqualError(t, ValidateVisibility(VisibilityCreator, "ir"), "team can only be used with team visibility")
+	assert.Error(t, ValidateVisibility("everyone", ""))
+	assert.True(t, ValidListing(""))
+	assert.True(t, ValidListing(ListingLock))
+	assert.False(t, ValidListing("show"))
+}
+
+func TestVisibleTo(t *testing.T) {
+	alice := Viewer{Username: "alice", Teams: []string{"ir"}}
+	bob := Viewer{Username: "bob"}
+	legacy := DistributedQuery{Name: "legacy", Creator: "carol"}
+	assert.True(t, legacy.VisibleTo(bob))
+	assert.False(t, legacy.Restricted())
+	public := DistributedQuery{Name: "public", Creator: "carol", Visibility: VisibilityPublic}
+	assert.True(t, public.VisibleTo(bob))
+	creator := DistributedQuery{Name: "creator", Creator: "alice", Visibility: VisibilityCreator}
+	assert.True(t, creator.VisibleTo(alice))
+	assert.False(t, creator.VisibleTo(bob))
+	team := DistributedQuery{Name: "team", Creator: "carol", Visibility: VisibilityTeam, VisibilityTeam: "ir"}
+	assert.True(t, team.VisibleTo(alice))
+	assert.False(t, team.VisibleTo(bob))
+	assert.True(t, team.VisibleTo(Viewer{Username: "carol"}))
+	assert.Equal(t, "team ir", team.VisibilitySummary())
+	assert.Equal(t, "creator only", creator.VisibilitySummary())
+	assert.Equal(t, VisibilityPublic, legacy.VisibilitySummary())
+}
+
+func TestLocked(t *testing.T) {
+	q := DistributedQuery{
+		Name:           "secret",
+		Creator:        "alice",
+		Query:          "SELECT * FROM users;",
+		Path:           "/etc/shadow",
+		Type:           CarveQueryType,
+		Description:    "Hunting persistence",
+		TicketID:       "IR-1234",
+		TicketURL:      "https://tickets.example.com/IR-1234",
+		Labels:         map[string]string{"team": "ir"},
+		Visibility:     VisibilityTeam,
+		VisibilityTeam: "ir",
+		Executions:     3,
+	}
+	locked := Locked(q)
+	assert.True(t, locked.Locked)
+	assert.Equal(t, "secret", locked.Name)
+	assert.Equal(t, 3, locked.Executions)
+	assert.Empty(t, locked.Query)
+	assert.Empty(t, locked.Path)
+	assert.Empty(t, locked.Description)
+	assert.Empty(t, locked.TicketID)
+	assert.Empty(t, locked.TicketURL)
+	assert.Empty(t, locked.Labels)
+	assert.Empty(t, locked.VisibilityTeam)
+}
+
+func TestVisibleScope(t *testing.T) {
+	q := dryRunQueries(t)
+	now := time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)
+	sql := q.DB.ToSQL(func(tx *gorm.DB) *gorm.DB {
+		var qs []DistributedQuery
+		return visibleScope(tx.Model(&DistributedQuery{}), Viewer{Username: "bob"}, now).Where("environment_id = ?", 1).Find(&qs)
+	})
+	assert.Contains(t, sql, `(visibility IS NULL OR visibility IN ('','public') OR creator = 'bob' OR (visibility = 'team' AND visibility_team IN ('')) OR name IN (SELECT "name" FROM "query_access_grants" WHERE (username = 'bob' AND expires_at > '2026-10-17 00:00:00') AND "query_access_grants"."deleted_at" IS NULL))`)
+	assert.Contains(t, sql, "environment_id = 1")
+	sql = q.DB.ToSQL(func(tx *gorm.DB) *gorm.DB {
+		var qs []DistributedQuery
+		return visibleScope(tx.Model(&DistributedQuery{}), Viewer{Username: "alice", Teams: []string{"ir", "soc"}}, now).Find(&qs)
+	})
+	assert.Contains(t, sql, "visibility_team IN ('ir','soc')")
+}
+
+func TestLockInvisible(t *testing.T) {
+	q := dryRunQueries(t)
+	qs := []DistributedQuery{
+		{Name: "public", Creator: "carol", Query: "SELECT 1;"},
+		{Name: "mine", Creator: "bob", Visibility: VisibilityCreator, Query: "SELECT 2;"},
+		{Name: "carve", Creator: "alice", Visibility: VisibilityTeam, VisibilityTeam: "ir", Type: CarveQueryType, Path: "/etc/shadow"},
+	}
+	listed, err := q.LockInvisible(context.Background(), qs, Viewer{Username: "bob"})
+	assert.NoError(t, err)
+	assert.Len(t, listed, 3)
+	assert.False(t, listed[0].Locked)
+	assert.Equal(t, "SELECT 2;", listed[1].Query)
+	assert.True(t, listed[2].Locked)
+	assert.Empty(t, listed[2].Path)
+	// Restricted queries can not be found by the context they hide
+	filtered, err := q.FilterContext(context.Background(), listed, ContextFilter{Ticket: "IR-1234"})
+	assert.NoError(t, err)
+	assert.Empty(t, filtered)
+	filtered, err = q.FilterContext(context.Background(), listed, ContextFilter{})
+	assert.NoError(t, err)
+	assert.Len(t, filtered, 3)
+}
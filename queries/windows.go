@@ -0,0 +1,222 @@
+package queries
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmpsec/osctrl/nodes"
+)
+
+const (
+	// QueryTargetEnrolledAfter defines the lower bound of the enrollment time of targeted nodes
+	QueryTargetEnrolledAfter string = "enrolled_after"
+	// QueryTargetEnrolledBefore defines the upper bound of the enrollment time of targeted nodes
+	QueryTargetEnrolledBefore string = "enrolled_before"
+	// QueryTargetSeenAfter defines the lower bound of the last time targeted nodes were seen
+	QueryTargetSeenAfter string = "seen_after"
+	// QueryTargetSeenBefore defines the upper bound of the last time targeted nodes were seen
+	QueryTargetSeenBefore string = "seen_before"
+)
+
+// TargetWindow to restrict the nodes targeted by a query to the ones enrolled or last seen within
+// time bounds. Zero bounds are not set. Bounds are stored as targets of the query and apply to the
+// nodes matched by the other targets, or to the whole environment when there are no other targets.
+// Enrollment bounds are evaluated again when nodes enroll, so nodes enrolling inside the window after
+// the query is created also get it. Nodes are last seen when they are updated, and last seen bounds
+// use the last update before each evaluation.
+type TargetWindow struct {
+	EnrolledAfter  time.Time `json:"enrolled_after"`
+	EnrolledBefore time.Time `json:"enrolled_before"`
+	SeenAfter      time.Time `json:"seen_after"`
+	SeenBefore     time.Time `json:"seen_before"`
+}
+
+// Helper to parse one bound of a window, empty values are not set
+func parseBound(name, value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be RFC3339 - %v", name, err)
+	}
+	return t.UTC(), nil
+}
+
+// ParseTargetWindow to parse the bounds of a window as RFC3339 values, empty values are not set
+func ParseTargetWindow(enrolledAfter, enrolledBefore, seenAfter, seenBefore string) (TargetWindow, error) {
+	var w TargetWindow
+	var err error
+	if w.EnrolledAfter, err = parseBound(QueryTargetEnrolledAfter, enrolledAfter); err != nil {
+		return w, err
+	}
+	if w.EnrolledBefore, err = parseBound(QueryTargetEnrolledBefore, enrolledBefore); err != nil {
+		return w, err
+	}
+	if w.SeenAfter, err = parseBound(QueryTargetSeenAfter, seenAfter); err != nil {
+		return w, err
+	}
+	if w.SeenBefore, err = parseBound(QueryTargetSeenBefore, seenBefore); err != nil {
+		return w, err
+	}
+	return w, w.Validate()
+}
+
+// Validate to check that the lower bounds of a window are before the upper bounds
+func (w TargetWindow) Validate() error {
+	if !w.EnrolledAfter.IsZero() && !w.EnrolledBefore.IsZero() && !w.EnrolledAfter.Before(w.EnrolledBefore) {
+		return fmt.Errorf("%s must be before %s", QueryTargetEnrolledAfter, QueryTargetEnrolledBefore)
+	}
+	if !w.SeenAfter.IsZero() && !w.SeenBefore.IsZero() && !w.SeenAfter.Before(w.SeenBefore) {
+		return fmt.Errorf("%s must be before %s", QueryTargetSeenAfter, QueryTargetSeenBefore)
+	}
+	return nil
+}
+
+// Empty to check if a window does not set any bound
+func (w TargetWindow) Empty() bool {
+	return w.EnrolledAfter.IsZero() && w.EnrolledBefore.IsZero() && w.SeenAfter.IsZero() && w.SeenBefore.IsZero()
+}
+
+// Helper to get the bounds of a window by target type, in a stable order
+func (w TargetWindow) bounds() []DistributedQueryTarget {
+	var bounds []DistributedQueryTarget
+	for _, b := range []struct {
+		tType string
+		value time.Time
+	}{
+		{QueryTargetEnrolledAfter, w.EnrolledAfter},
+		{QueryTargetEnrolledBefore, w.EnrolledBefore},
+		{QueryTargetSeenAfter, w.SeenAfter},
+		{QueryTargetSeenBefore, w.SeenBefore},
+	} {
+		if !b.value.IsZero() {
+			bounds = append(bounds, DistributedQueryTarget{Type: b.tType, Value: b.value.UTC().Format(time.RFC3339)})
+		}
+	}
+	return bounds
+}
+
+// String to describe the effective window, with the bounds that are set
+func (w TargetWindow) String() string {
+	var parts []string
+	if !w.EnrolledAfter.IsZero() || !w.EnrolledBefore.IsZero() {
+		parts = append(parts, "enrolled "+describeBounds(w.EnrolledAfter, w.EnrolledBefore))
+	}
+	if !w.SeenAfter.IsZero() || !w.SeenBefore.IsZero() {
+		parts = append(parts, "last seen "+describeBounds(w.SeenAfter, w.SeenBefore))
+	}
+	return strings.Join(parts, " and ")
+}
+
+// Helper to describe a pair of bounds
+func describeBounds(after, before time.Time) string {
+	switch {
+	case after.IsZero():
+		return "before " + before.UTC().Format(time.RFC3339)
+	case before.IsZero():
+		return "after " + after.UTC().Format(time.RFC3339)
+	}
+	return "between " + after.UTC().Format(time.RFC3339) + " and " + before.UTC().Format(time.RFC3339)
+}
+
+// IsWindowTarget to check if a target is a bound of a time window
+func IsWindowTarget(tType string) bool {
+	switch tType {
+	case QueryTargetEnrolledAfter, QueryTargetEnrolledBefore, QueryTargetSeenAfter, QueryTargetSeenBefore:
+		return true
+	}
+	return false
+}
+
+// WindowOfTargets to get the window set by the targets of a query, invalid bounds are ignored
+func WindowOfTargets(targets []DistributedQueryTarget) TargetWindow {
+	var w TargetWindow
+	for _, t := range targets {
+		if !IsWindowTarget(t.Type) {
+			continue
+		}
+		value, err := time.Parse(time.RFC3339, t.Value)
+		if err != nil {
+			continue
+		}
+		switch t.Type {
+		case QueryTargetEnrolledAfter:
+			w.EnrolledAfter = value.UTC()
+		case QueryTargetEnrolledBefore:
+			w.EnrolledBefore = value.UTC()
+		case QueryTargetSeenAfter:
+			w.SeenAfter = value.UTC()
+		case QueryTargetSeenBefore:
+			w.SeenBefore = value.UTC()
+		}
+	}
+	return w
+}
+
+// Matches to check if a node was enrolled and last seen within the window
+func (w TargetWindow) Matches(node nodes.OsqueryNode) bool {
+	if !w.EnrolledAfter.IsZero() && node.CreatedAt.Before(w.EnrolledAfter) {
+		return false
+	}
+	if !w.EnrolledBefore.IsZero() && !node.CreatedAt.Before(w.EnrolledBefore) {
+		return false
+	}
+	if !w.SeenAfter.IsZero() && node.UpdatedAt.Before(w.SeenAfter) {
+		return false
+	}
+	if !w.SeenBefore.IsZero() && !node.UpdatedAt.Before(w.SeenBefore) {
+		return false
+	}
+	return true
+}
+
+// Helper to get the SQL condition for the nodes within the window
+func (w TargetWindow) condition() (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	if !w.EnrolledAfter.IsZero() {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, w.EnrolledAfter)
+	}
+	if !w.EnrolledBefore.IsZero() {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, w.EnrolledBefore)
+	}
+	if !w.SeenAfter.IsZero() {
+		conditions = append(conditions, "updated_at >= ?")
+		args = append(args, w.SeenAfter)
+	}
+	if !w.SeenBefore.IsZero() {
+		conditions = append(conditions, "updated_at < ?")
+		args = append(args, w.SeenBefore)
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// CreateWindowTargets to create the targets of a query for the bounds of a window
+func (q *Queries) CreateWindowTargets(name string, w TargetWindow) error {
+	if err := w.Validate(); err != nil {
+		return err
+	}
+	for _, b := range w.bounds() {
+		if err := q.CreateTarget(name, b.Type, b.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetWindowMatched to record how many nodes matched the window of a query when it was last evaluated
+func (q *Queries) SetWindowMatched(name string, envid uint, matched int) error {
+	query, err := q.Get(name, envid)
+	if err != nil {
+		return err
+	}
+	if err := q.DB.Model(&query).Updates(map[string]interface{}{"window_matched": matched, "window_evaluated": time.Now()}).Error; err != nil {
+		return fmt.Errorf("Updates %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package queries
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTargetWindow(t *testing.T) {
+	w, err := ParseTargetWindow("", "", "", "")
+	assert.NoError(t, err)
+	assert.True(t, w.Empty())
+	w, err = ParseTargetWindow("2023-01-01T00:00:00Z", " 2023-01-03T00:00:00+01:00 ", "", "2023-02-01T00:00:00Z")
+	assert.NoError(t, err)
+	assert.False(t, w.Empty())
+	assert.Equal(t, time.Date(2023, 1, 2, 23, 0, 0, 0, time.UTC), w.EnrolledBefore)
+	assert.True(t, w.SeenAfter.IsZero())
+	assert.Equal(t, "enrolled between 2023-01-01T00:00:00Z and 2023-01-02T23:00:00Z and last seen before 2023-02-01T00:00:00Z", w.String())
+	_, err = ParseTargetWindow("yesterday", "", "", "")
+	assert.Error(t, err)
+	_, err = ParseTargetWindow("", "", "2023-01-02T00:00:00Z", "2023-01-01T00:00:00Z")
+	assert.Error(t, err)
+}
+
+func TestWindowOfTargets(t *testing.T) {
+	w, _ := ParseTargetWindow("2023-01-01T00:00:00Z", "", "2023-01-05T00:00:00Z", "")
+	targets := append([]DistributedQueryTarget{{Type: QueryTargetPlatform, Value: "darwin"}}, w.bounds()...)
+	assert.Equal(t, []DistributedQueryTarget{
+		{Type: QueryTargetPlatform, Value: "darwin"},
+		{Type: QueryTargetEnrolledAfter, Value: "2023-01-01T00:00:00Z"},
+		{Type: QueryTargetSeenAfter, Value: "2023-01-05T00:00:00Z"},
+	}, targets)
+	assert.Equal(t, w, WindowOfTargets(targets))
+	assert.True(t, WindowOfTargets([]DistributedQueryTarget{{Type: QueryTargetSeenBefore, Value: "broken"}}).Empty())
+}
+
+func TestWindowTargets(t *testing.T) {
+	enrolled := time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)
+	node := nodes.OsqueryNode{UUID: "uuid-1", Platform: "darwin", Environment: "dev"}
+	node.CreatedAt = enrolled
+	node.UpdatedAt = enrolled.Add(72 * time.Hour)
+	window := func(enrolledAfter, enrolledBefore, seenAfter, seenBefore string) []DistributedQueryTarget {
+		w, err := ParseTargetWindow(enrolledAfter, enrolledBefore, seenAfter, seenBefore)
+		assert.NoError(t, err)
+		return w.bounds()
+	}
+	// Windows on their own select the nodes of the environment within the window
+	assert.True(t, isQueryTarget(node, window("2023-01-01T00:00:00Z", "", "", "")))
+	assert.True(t, isQueryTarget(node, window("2023-01-02T00:00:00Z", "", "", "")))
+	assert.False(t, isQueryTarget(node, window("", "2023-01-02T00:00:00Z", "", "")))
+	assert.False(t, isQueryTarget(node, window("", "", "2023-01-06T00:00:00Z", "")))
+	assert.True(t, isQueryTarget(node, window("", "", "", "2023-01-06T00:00:00Z")))
+	// Windows restrict the nodes of the other targets
+	platform := []DistributedQueryTarget{{Type: QueryTargetPlatform, Value: "darwin"}}
+	other := []DistributedQueryTarget{{Type: QueryTargetPlatform, Value: "windows"}}
+	assert.True(t, isQueryTarget(node, append(platform, window("2023-01-01T00:00:00Z", "", "", "")...)))
+	assert.False(t, isQueryTarget(node, append(platform, window("2023-01-03T00:00:00Z", "", "", "")...)))
+	assert.False(t, isQueryTarget(node, append(other, window("2023-01-01T00:00:00Z", "", "", "")...)))
+	// Conditions to materialize
+	condition, args := targetsCondition(window("2023-01-01T00:00:00Z", "", "", "2023-01-06T00:00:00Z"))
+	assert.Equal(t, "(created_at >= ? AND updated_at < ?)", condition)
+	assert.Equal(t, 2, len(args))
+	condition, args = targetsCondition(append(platform, window("", "2023-01-03T00:00:00Z", "", "")...))
+	assert.Equal(t, "(platform IN ?) AND (created_at < ?)", condition)
+	assert.Equal(t, []interface{}{[]string{"darwin"}, time.Date(2023, 1, 3, 0, 0, 0, 0, time.UTC)}, args)
+}
@@ -0,0 +1,180 @@
+package reports
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/spf13/viper"
+)
+
+const (
+	// Key in the configuration file for SMTP
+	smtpConfigKey = "smtp"
+	// Line length for base64 attachments
+	base64LineLength = 76
+)
+
+// LoadSMTP to load the SMTP configuration from JSON file
+func LoadSMTP(file string) (types.SMTPConfiguration, error) {
+	var _smtpCfg types.SMTPConfiguration
+	log.Printf("Loading %s", file)
+	// Load file and read config
+	viper.SetConfigFile(file)
+	if err := viper.ReadInConfig(); err != nil {
+		return _smtpCfg, err
+	}
+	cfgRaw := viper.Sub(smtpConfigKey)
+	if cfgRaw == nil {
+		return _smtpCfg, fmt.Errorf("JSON key %s not found in %s", smtpConfigKey, file)
+	}
+	if err := cfgRaw.Unmarshal(&_smtpCfg); err != nil {
+		return _smtpCfg, err
+	}
+	// No errors!
+	return _smtpCfg, nil
+}
+
+// Open to read the content of a report, from the DB or from S3
+func (r *ReportManager) Open(ctx context.Context, report Report) (io.ReadCloser, error) {
+	if report.Storage != StorageS3 {
+		return io.NopCloser(bytes.NewReader(report.Content)), nil
+	}
+	if r.S3 == nil {
+		return nil, fmt.Errorf("report %s is in S3, but S3 is not configured", report.Name)
+	}
+	obj, err := r.S3.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(r.S3.Bucket),
+		Key:    aws.String(report.Location),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("GetObject - %s", err)
+	}
+	return obj.Body, nil
+}
+
+// lineWriter to split base64 attachments in lines, as required by SMTP
+type lineWriter struct {
+	w   io.Writer
+	col int
+}
+
+func (l *lineWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := base64LineLength - l.col
+		if n > len(p) {
+			n = len(p)
+		}
+		if _, err := l.w.Write(p[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		l.col += n
+		p = p[n:]
+		if l.col == base64LineLength {
+			if _, err := l.w.Write([]byte("\r\n")); err != nil {
+				return written, err
+			}
+			l.col = 0
+		}
+	}
+	return written, nil
+}
+
+// Email to send a report as attachment to the recipients, streaming the content to the SMTP server
+func (r *ReportManager) Email(ctx context.Context, report Report, recipients []string) error {
+	if r.SMTP == nil {
+		return fmt.Errorf("SMTP is not configured")
+	}
+	var to []string
+	for _, rcpt := range recipients {
+		if rcpt = strings.TrimSpace(rcpt); rcpt != "" {
+			to = append(to, rcpt)
+		}
+	}
+	if len(to) == 0 {
+		return fmt.Errorf("no recipients")
+	}
+	content, err := r.Open(ctx, report)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+	addr := fmt.Sprintf("%s:%d", r.SMTP.Host, r.SMTP.Port)
+	c, err := smtp.Dial(addr)
+	if err != nil {
+		return fmt.Errorf("Dial %v", err)
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(nil); err != nil {
+			return fmt.Errorf("StartTLS %v", err)
+		}
+	}
+	if r.SMTP.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", r.SMTP.Username, r.SMTP.Password, r.SMTP.Host)); err != nil {
+			return fmt.Errorf("Auth %v", err)
+		}
+	}
+	if err := c.Mail(r.SMTP.From); err != nil {
+		return fmt.Errorf("Mail %v", err)
+	}
+	for _, rcpt := range to {
+		if err := c.Rcpt(rcpt); err != nil {
+			return fmt.Errorf("Rcpt %s %v", rcpt, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("Data %v", err)
+	}
+	mw := multipart.NewWriter(w)
+	headers := []string{
+		"From: " + r.SMTP.From,
+		"To: " + strings.Join(to, ", "),
+		fmt.Sprintf("Subject: osctrl %s report for %s", report.Period, report.Environment),
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/mixed; boundary=" + mw.Boundary(),
+	}
+	if _, err := fmt.Fprintf(w, "%s\r\n\r\n", strings.Join(headers, "\r\n")); err != nil {
+		return err
+	}
+	body, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(body, "Attached is the osctrl %s report for %s, from %s to %s.\r\n", report.Period, report.Environment, report.PeriodStart.Format("2006-01-02"), report.PeriodEnd.Format("2006-01-02"))
+	attachment, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {ContentType(report.Format)},
+		"Content-Transfer-Encoding": {"base64"},
+		"Content-Disposition":       {fmt.Sprintf("attachment; filename=%q", report.Name)},
+	})
+	if err != nil {
+		return err
+	}
+	encoder := base64.NewEncoder(base64.StdEncoding, &lineWriter{w: attachment})
+	if _, err := io.Copy(encoder, content); err != nil {
+		return fmt.Errorf("error attaching report %v", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+	if err := mw.Close(); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("Data %v", err)
+	}
+	return c.Quit()
+}
@@ -0,0 +1,476 @@
+package reports
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jmpsec/osctrl/locales"
+	"github.com/jmpsec/osctrl/metrics"
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/jmpsec/osctrl/queries"
+	"gorm.io/gorm"
+)
+
+// Default template for reports, operators can override it with TemplateFile
+//
+//go:embed templates/report.html
+var defaultTemplate string
+
+// Keys in the catalogs for the names of the types of ingested data
+var ingestedNames = map[uint8]string{
+	uint8(metrics.IngestedStatus):     "report.ingested.status",
+	uint8(metrics.IngestedResult):     "report.ingested.result",
+	uint8(metrics.IngestedQueryRead):  "report.ingested.read",
+	uint8(metrics.IngestedQueryWrite): "report.ingested.write",
+	uint8(metrics.IngestedConfig):     "report.ingested.config",
+	uint8(metrics.IngestedCarveInit):  "report.ingested.carveinit",
+	uint8(metrics.IngestedCarveBlock): "report.ingested.carveblock",
+}
+
+// ReportRequest to generate one report
+type ReportRequest struct {
+	EnvironmentID uint
+	Environment   string
+	Period        string
+	Format        string
+	Creator       string
+	InactiveHours int64
+	Locale        string
+	// Category of the environments of a fleet wide report, empty for all environments
+	Category string
+}
+
+// NodeCounts to hold the number of nodes at generation time
+type NodeCounts struct {
+	Total    int64
+	Active   int64
+	Inactive int64
+}
+
+// PlatformCount to hold the number of nodes per platform
+type PlatformCount struct {
+	Platform string
+	Nodes    int64
+}
+
+// AttributeCount to hold the number of nodes with custom attributes of a namespace
+type AttributeCount struct {
+	Namespace string
+	Nodes     int64
+}
+
+// DailyCount to hold a daily value within the report period
+type DailyCount struct {
+	Day   time.Time
+	Count int64
+}
+
+// TriggerCount to hold the number of removed nodes per trigger
+type TriggerCount struct {
+	Trigger string
+	Nodes   int64
+}
+
+// CreatorCount to hold the number of queries per creator
+type CreatorCount struct {
+	Creator string
+	Queries int64
+}
+
+// QueryCount to hold the executions of one query
+type QueryCount struct {
+	Name       string
+	Creator    string
+	Query      string
+	Executions int
+	Errors     int
+}
+
+// IngestedVolume to hold the bytes ingested per type of data
+type IngestedVolume struct {
+	DataType uint8
+	Name     string
+	Bytes    int64
+}
+
+// ReportData to hold the values rendered in a report. Lists that can be long are channels,
+// so they are streamed from the DB while the template is executed.
+type ReportData struct {
+	Title         string
+	Locale        string
+	Environment   string
+	Period        string
+	Start         time.Time
+	End           time.Time
+	Generated     time.Time
+	Nodes         NodeCounts
+	Platforms     []PlatformCount
+	Attributes    []AttributeCount
+	Enrollments   []DailyCount
+	Enrolled      int64
+	Removed       []TriggerCount
+	RemovedTotal  int64
+	Creators      []CreatorCount
+	QueriesTotal  int64
+	TopQueries    <-chan QueryCount
+	FailedQueries <-chan QueryCount
+	Ingested      []IngestedVolume
+	IngestedTotal int64
+	Contacting    []nodes.NodeDecommission
+}
+
+// ContentType to get the HTTP content type for a report format
+func ContentType(format string) string {
+	if format == FormatPDF {
+		return "application/pdf"
+	}
+	return "text/html; charset=utf-8"
+}
+
+// GenReportName to generate the name of a report
+func GenReportName(environment, period string, start time.Time, format string) string {
+	return fmt.Sprintf("osctrl-%s-%s-%s.%s", environment, period, start.Format("2006-01-02"), format)
+}
+
+// Helper to scope a query to the environment of the report, all environments of the category or all of them without one
+func scopeEnv(query *gorm.DB, req ReportRequest) *gorm.DB {
+	if req.EnvironmentID != 0 {
+		return query.Where("environment_id = ?", req.EnvironmentID)
+	}
+	if req.Category != "" {
+		return query.Where("environment_id IN (SELECT id FROM tls_environments WHERE category = ? AND deleted_at IS NULL)", req.Category)
+	}
+	return query
+}
+
+// Helper to stream the rows of a queries query into a channel, until ctx is cancelled
+func streamQueries(ctx context.Context, query *gorm.DB) <-chan QueryCount {
+	ch := make(chan QueryCount)
+	go func() {
+		defer close(ch)
+		rows, err := query.Rows()
+		if err != nil {
+			log.Printf("error streaming queries for report %v", err)
+			return
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var q QueryCount
+			if err := query.ScanRows(rows, &q); err != nil {
+				log.Printf("error scanning queries for report %v", err)
+				return
+			}
+			select {
+			case ch <- q:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// Collect to gather the data of a report, using aggregations so nodes are not loaded in memory
+func (r *ReportManager) Collect(ctx context.Context, req ReportRequest, start, end time.Time) (ReportData, error) {
+	db := r.read().WithContext(ctx)
+	data := ReportData{
+		Title:       locales.T(req.Locale, "report.title", req.Environment),
+		Locale:      locales.Normalize(req.Locale),
+		Environment: req.Environment,
+		Period:      req.Period,
+		Start:       start,
+		End:         end,
+		Generated:   time.Now().UTC(),
+	}
+	// Nodes
+	nodesQuery := func() *gorm.DB {
+		return scopeEnv(db.Model(&nodes.OsqueryNode{}), req)
+	}
+	if err := nodesQuery().Count(&data.Nodes.Total).Error; err != nil {
+		return data, fmt.Errorf("Count %v", err)
+	}
+	tHours := time.Now().Add(time.Duration(req.InactiveHours) * time.Hour)
+	if err := nodesQuery().Where("updated_at > ?", tHours).Count(&data.Nodes.Active).Error; err != nil {
+		return data, fmt.Errorf("Count %v", err)
+	}
+	data.Nodes.Inactive = data.Nodes.Total - data.Nodes.Active
+	if err := nodesQuery().Select("platform, count(*) as nodes").Group("platform").Order("nodes DESC").Scan(&data.Platforms).Error; err != nil {
+		return data, fmt.Errorf("Platforms %v", err)
+	}
+	attributes := db.Model(&nodes.NodeAttribute{}).Where("node_id IN (?)", nodesQuery().Select("id"))
+	if err := attributes.Select("namespace, count(distinct node_id) as nodes").Group("namespace").Order("nodes DESC").Scan(&data.Attributes).Error; err != nil {
+		return data, fmt.Errorf("Attributes %v", err)
+	}
+	// Enrollments and removals within the period
+	if err := nodesQuery().Select("date_trunc('day', created_at) as day, count(*) as count").Where("created_at >= ? AND created_at < ?", start, end).Group("day").Order("day").Scan(&data.Enrollments).Error; err != nil {
+		return data, fmt.Errorf("Enrollments %v", err)
+	}
+	for _, e := range data.Enrollments {
+		data.Enrolled += e.Count
+	}
+	archived := scopeEnv(db.Model(&nodes.ArchiveOsqueryNode{}), req)
+	if err := archived.Select("trigger, count(*) as nodes").Where("created_at >= ? AND created_at < ?", start, end).Group("trigger").Order("nodes DESC").Scan(&data.Removed).Error; err != nil {
+		return data, fmt.Errorf("Removed %v", err)
+	}
+	for _, rm := range data.Removed {
+		data.RemovedTotal += rm.Nodes
+	}
+	// Decommissioned nodes still contacting after the grace period, to be chased manually
+	if err := scopeEnv(db.Model(&nodes.NodeDecommission{}), req).Where("state = ?", nodes.DecommissionContacting).Order("created_at").Find(&data.Contacting).Error; err != nil {
+		return data, fmt.Errorf("Contacting %v", err)
+	}
+	// Queries within the period
+	queriesQuery := func() *gorm.DB {
+		return scopeEnv(db.Model(&queries.DistributedQuery{}), req).Where("created_at >= ? AND created_at < ?", start, end)
+	}
+	if err := queriesQuery().Count(&data.QueriesTotal).Error; err != nil {
+		return data, fmt.Errorf("Count %v", err)
+	}
+	if err := queriesQuery().Select("creator, count(*) as queries").Group("creator").Order("queries DESC").Limit(DefaultTopQueries).Scan(&data.Creators).Error; err != nil {
+		return data, fmt.Errorf("Creators %v", err)
+	}
+	// Reports are shared beyond who can see restricted queries, so only public queries are listed
+	publicQueries := func() *gorm.DB {
+		return queriesQuery().Where("visibility IS NULL OR visibility IN ?", []string{"", queries.VisibilityPublic})
+	}
+	data.TopQueries = streamQueries(ctx, publicQueries().Select("name, creator, query, executions, errors").Order("executions DESC").Limit(DefaultTopQueries))
+	data.FailedQueries = streamQueries(ctx, publicQueries().Select("name, creator, query, executions, errors").Where("errors > 0").Order("errors DESC").Limit(DefaultTopQueries))
+	// Ingested data within the period
+	ingested := scopeEnv(db.Model(&metrics.IngestedData{}), req)
+	if err := ingested.Select("data_type, sum(bytes_ingested) as bytes").Where("created_at >= ? AND created_at < ?", start, end).Group("data_type").Order("bytes DESC").Scan(&data.Ingested).Error; err != nil {
+		return data, fmt.Errorf("Ingested %v", err)
+	}
+	for i := range data.Ingested {
+		data.Ingested[i].Name = locales.T(req.Locale, ingestedNames[data.Ingested[i].DataType])
+		data.IngestedTotal += data.Ingested[i].Bytes
+	}
+	return data, nil
+}
+
+// Render to execute the report template, the default one or TemplateFile if set.
+// Labels and dates use the locale of the report.
+func (r *ReportManager) Render(w io.Writer, data ReportData) error {
+	data.Locale = locales.Normalize(data.Locale)
+	funcMap := template.FuncMap{
+		"bytes": func(b int64) string {
+			const unit = 1024
+			if b < unit {
+				return fmt.Sprintf("%d B", b)
+			}
+			div, exp := int64(unit), 0
+			for n := b / unit; n >= unit; n /= unit {
+				div *= unit
+				exp++
+			}
+			return fmt.Sprintf("%.1f %cB", float64(b)/float64(div), "KMGTPE"[exp])
+		},
+		"percent": func(part, total int64) string {
+			if total == 0 {
+				return "0%"
+			}
+			return fmt.Sprintf("%.1f%%", float64(part)*100/float64(total))
+		},
+	}
+	for name, f := range locales.FuncMap(data.Locale) {
+		funcMap[name] = f
+	}
+	t := template.New("report").Funcs(funcMap)
+	var err error
+	if r.TemplateFile != "" {
+		t, err = t.ParseFiles(r.TemplateFile)
+		if err == nil {
+			t = t.Lookup(filepath.Base(r.TemplateFile))
+		}
+	} else {
+		t, err = t.Parse(defaultTemplate)
+	}
+	if err != nil {
+		return fmt.Errorf("error parsing report template %v", err)
+	}
+	return t.Execute(w, data)
+}
+
+// Helper to convert HTML to PDF with the configured command, reading HTML from stdin and writing PDF to stdout
+func (r *ReportManager) convertPDF(ctx context.Context, html io.Reader) (io.Reader, func() error, error) {
+	args := strings.Fields(r.PDFConverter)
+	if len(args) == 0 {
+		return nil, nil, fmt.Errorf("PDF converter is not configured")
+	}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = html
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, fmt.Errorf("StdoutPipe %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, nil, fmt.Errorf("error starting PDF converter %v", err)
+	}
+	return out, cmd.Wait, nil
+}
+
+// countingReader to keep the size of a streamed report
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Generate to render a report and store it, in S3 if configured or in the DB otherwise.
+// The template output is streamed to the storage, without keeping the whole report in memory for S3.
+func (r *ReportManager) Generate(ctx context.Context, req ReportRequest) (Report, error) {
+	if _, ok := ValidPeriods[req.Period]; !ok {
+		return Report{}, fmt.Errorf("invalid period %s", req.Period)
+	}
+	if req.Format == "" {
+		req.Format = FormatHTML
+	}
+	if _, ok := ValidFormats[req.Format]; !ok {
+		return Report{}, fmt.Errorf("invalid format %s", req.Format)
+	}
+	if req.Format == FormatPDF && r.PDFConverter == "" {
+		return Report{}, fmt.Errorf("PDF reports need a PDF converter")
+	}
+	if req.EnvironmentID == 0 {
+		req.Environment = FleetWide
+		if req.Category != "" {
+			req.Environment = FleetWide + "-" + req.Category
+		}
+	}
+	start, end, err := ParsePeriod(req.Period, time.Now())
+	if err != nil {
+		return Report{}, err
+	}
+	// Cancelling stops the goroutines streaming data to the template
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	data, err := r.Collect(ctx, req, start, end)
+	if err != nil {
+		return Report{}, err
+	}
+	report := Report{
+		Name:          GenReportName(req.Environment, req.Period, start, req.Format),
+		EnvironmentID: req.EnvironmentID,
+		Environment:   req.Environment,
+		Period:        req.Period,
+		PeriodStart:   start,
+		PeriodEnd:     end,
+		Format:        req.Format,
+		Creator:       req.Creator,
+	}
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	go func() {
+		pw.CloseWithError(r.Render(pw, data))
+	}()
+	var content io.Reader = pr
+	var wait func() error
+	if req.Format == FormatPDF {
+		content, wait, err = r.convertPDF(ctx, pr)
+		if err != nil {
+			return Report{}, err
+		}
+	}
+	counter := &countingReader{r: content}
+	if r.S3 != nil {
+		report.Storage = StorageS3
+		report.Location = fmt.Sprintf("reports/%s/%s", req.Environment, report.Name)
+		err = r.S3.Upload(ctx, report.Location, counter, ContentType(req.Format))
+	} else {
+		report.Storage = StorageDB
+		report.Content, err = io.ReadAll(counter)
+	}
+	if err != nil {
+		return Report{}, fmt.Errorf("error storing report %v", err)
+	}
+	if wait != nil {
+		if err := wait(); err != nil {
+			return Report{}, fmt.Errorf("error converting to PDF %v", err)
+		}
+	}
+	report.Size = counter.n
+	if err := r.DB.Create(&report).Error; err != nil {
+		return Report{}, fmt.Errorf("Create %v", err)
+	}
+	return report, nil
+}
+
+// RunSchedules to generate the reports of due schedules, emailing them to recipients if SMTP is configured
+func (r *ReportManager) RunSchedules(ctx context.Context, inactiveHours int64) {
+	now := time.Now()
+	var due []ReportSchedule
+	if err := r.DB.WithContext(ctx).Where("enabled = ? AND next_run <= ?", true, now).Find(&due).Error; err != nil {
+		log.Printf("error getting report schedules %v", err)
+		return
+	}
+	for _, s := range due {
+		// With several instances running, only the one that claims the schedule generates the report
+		claimed, err := r.claimSchedule(s, now)
+		if err != nil {
+			log.Printf("error claiming report schedule %d %v", s.ID, err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+		report, err := r.Generate(ctx, ReportRequest{
+			EnvironmentID: s.EnvironmentID,
+			Environment:   s.Environment,
+			Period:        FrequencyPeriod(s.Frequency),
+			Format:        s.Format,
+			Creator:       "scheduler",
+			InactiveHours: inactiveHours,
+			Locale:        r.envLocale(s.Environment),
+		})
+		if err != nil {
+			log.Printf("error generating scheduled report %d %v", s.ID, err)
+			continue
+		}
+		log.Printf("Generated scheduled report %s", report.Name)
+		if s.Recipients == "" || r.SMTP == nil {
+			continue
+		}
+		if err := r.Email(ctx, report, strings.Split(s.Recipients, ",")); err != nil {
+			log.Printf("error emailing report %s %v", report.Name, err)
+		}
+	}
+}
+
+// Helper to get the locale for the reports of an environment, the default one if unknown
+func (r *ReportManager) envLocale(environment string) string {
+	if r.EnvLocale == nil || environment == FleetWide {
+		return locales.DefaultLocale
+	}
+	return r.EnvLocale(environment)
+}
+
+// StartScheduler to check for due report schedules periodically, until ctx is cancelled
+func (r *ReportManager) StartScheduler(ctx context.Context, interval time.Duration, inactiveHours func() int64) {
+	if interval <= 0 {
+		interval = DefaultSchedulerInterval
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				r.RunSchedules(ctx, inactiveHours())
+			}
+		}
+	}()
+}
@@ -0,0 +1,8 @@
+module reports
+
+go 1.17
+
+require (
+	github.com/stretchr/testify v1.8.1
+	gorm.io/gorm v1.24.3
+)
@@ -0,0 +1,249 @@
+package reports
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jmpsec/osctrl/types"
+	"gorm.io/gorm"
+)
+
+const (
+	// PeriodLastWeek for reports of the previous week, from Monday to Sunday
+	PeriodLastWeek string = "last-week"
+	// PeriodLastMonth for reports of the previous calendar month
+	PeriodLastMonth string = "last-month"
+	// FrequencyWeekly for reports generated every Monday
+	FrequencyWeekly string = "weekly"
+	// FrequencyMonthly for reports generated the first day of every month
+	FrequencyMonthly string = "monthly"
+	// FormatHTML for reports rendered as HTML
+	FormatHTML string = "html"
+	// FormatPDF for reports rendered as HTML and converted to PDF
+	FormatPDF string = "pdf"
+	// StorageDB for reports stored in the DB
+	StorageDB string = "db"
+	// StorageS3 for reports stored in S3
+	StorageS3 string = "s3"
+	// FleetWide is the environment name for reports of all environments
+	FleetWide string = "all"
+	// DefaultSchedulerInterval is the default interval to check for scheduled reports
+	DefaultSchedulerInterval = 5 * time.Minute
+	// DefaultTopQueries is the default number of queries in reports
+	DefaultTopQueries = 10
+)
+
+// ValidPeriods to check validity of report periods
+var ValidPeriods = map[string]struct{}{
+	PeriodLastWeek:  {},
+	PeriodLastMonth: {},
+}
+
+// ValidFrequencies to check validity of report schedules
+var ValidFrequencies = map[string]struct{}{
+	FrequencyWeekly:  {},
+	FrequencyMonthly: {},
+}
+
+// ValidFormats to check validity of report formats
+var ValidFormats = map[string]struct{}{
+	FormatHTML: {},
+	FormatPDF:  {},
+}
+
+// Report to keep generated reports, the content is in the DB or in S3
+type Report struct {
+	gorm.Model
+	Name          string `gorm:"index"`
+	EnvironmentID uint   `gorm:"index"`
+	Environment   string
+	Period        string
+	PeriodStart   time.Time
+	PeriodEnd     time.Time
+	Format        string
+	Storage       string
+	Location      string
+	Content       []byte `json:"-"`
+	Size          int64
+	Creator       string
+}
+
+// ReportSchedule to keep the periodic generation of reports for one environment, or all with EnvironmentID 0
+type ReportSchedule struct {
+	gorm.Model
+	EnvironmentID uint `gorm:"index"`
+	Environment   string
+	Frequency     string
+	Format        string
+	Recipients    string
+	Enabled       bool
+	LastRun       time.Time
+	NextRun       time.Time
+	Creator       string
+}
+
+// ReportManager to generate, store and schedule reports
+type ReportManager struct {
+	DB           *gorm.DB
+	Reader       func() *gorm.DB
+	TemplateFile string
+	PDFConverter string
+	S3           *ReportsS3
+	SMTP         *types.SMTPConfiguration
+	// EnvLocale to get the locale of an environment for scheduled reports
+	EnvLocale func(environment string) string
+}
+
+// CreateReports to initialize the reports struct and its tables
+func CreateReports(backend *gorm.DB) *ReportManager {
+	var r *ReportManager
+	r = &ReportManager{DB: backend}
+	// table reports
+	if err := backend.AutoMigrate(&Report{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (reports): %v", err)
+	}
+	// table report_schedules
+	if err := backend.AutoMigrate(&ReportSchedule{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (report_schedules): %v", err)
+	}
+	return r
+}
+
+// Helper to get the connection for reads that tolerate replication lag, DB if there is no Reader
+func (r *ReportManager) read() *gorm.DB {
+	if r.Reader != nil {
+		return r.Reader()
+	}
+	return r.DB
+}
+
+// ParsePeriod to get the start and end of a report period, relative to now and in UTC
+func ParsePeriod(period string, now time.Time) (time.Time, time.Time, error) {
+	now = now.UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	switch period {
+	case PeriodLastWeek:
+		// Weeks start on Monday
+		offset := (int(today.Weekday()) + 6) % 7
+		end := today.AddDate(0, 0, -offset)
+		return end.AddDate(0, 0, -7), end, nil
+	case PeriodLastMonth:
+		end := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return end.AddDate(0, -1, 0), end, nil
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("invalid period %s", period)
+}
+
+// NextRun to get when a schedule runs next, after the provided time
+func NextRun(frequency string, after time.Time) (time.Time, error) {
+	switch frequency {
+	case FrequencyWeekly:
+		_, end, _ := ParsePeriod(PeriodLastWeek, after)
+		return end.AddDate(0, 0, 7), nil
+	case FrequencyMonthly:
+		_, end, _ := ParsePeriod(PeriodLastMonth, after)
+		return end.AddDate(0, 1, 0), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid frequency %s", frequency)
+}
+
+// FrequencyPeriod to get the report period covered by a schedule frequency
+func FrequencyPeriod(frequency string) string {
+	if frequency == FrequencyMonthly {
+		return PeriodLastMonth
+	}
+	return PeriodLastWeek
+}
+
+// Gets to retrieve all reports, for one environment if envid is not 0, newest first
+func (r *ReportManager) Gets(envid uint) ([]Report, error) {
+	var reports []Report
+	query := r.read().Omit("content").Order("created_at DESC")
+	if envid != 0 {
+		query = query.Where("environment_id = ?", envid)
+	}
+	if err := query.Find(&reports).Error; err != nil {
+		return reports, err
+	}
+	return reports, nil
+}
+
+// Get to retrieve one report by id, including its content if stored in the DB
+func (r *ReportManager) Get(id uint) (Report, error) {
+	var report Report
+	if err := r.DB.First(&report, id).Error; err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// Delete to remove a report
+func (r *ReportManager) Delete(id uint) error {
+	if err := r.DB.Unscoped().Delete(&Report{}, id).Error; err != nil {
+		return fmt.Errorf("Delete %v", err)
+	}
+	return nil
+}
+
+// GetSchedules to retrieve all report schedules
+func (r *ReportManager) GetSchedules() ([]ReportSchedule, error) {
+	var schedules []ReportSchedule
+	if err := r.read().Order("environment").Find(&schedules).Error; err != nil {
+		return schedules, err
+	}
+	return schedules, nil
+}
+
+// NewSchedule to add a periodic report for one environment, or all with envid 0
+func (r *ReportManager) NewSchedule(envid uint, environment, frequency, format string, recipients []string, creator string) (ReportSchedule, error) {
+	if _, ok := ValidFrequencies[frequency]; !ok {
+		return ReportSchedule{}, fmt.Errorf("invalid frequency %s", frequency)
+	}
+	if _, ok := ValidFormats[format]; !ok {
+		return ReportSchedule{}, fmt.Errorf("invalid format %s", format)
+	}
+	next, err := NextRun(frequency, time.Now())
+	if err != nil {
+		return ReportSchedule{}, err
+	}
+	schedule := ReportSchedule{
+		EnvironmentID: envid,
+		Environment:   environment,
+		Frequency:     frequency,
+		Format:        format,
+		Recipients:    strings.Join(recipients, ","),
+		Enabled:       true,
+		NextRun:       next,
+		Creator:       creator,
+	}
+	if err := r.DB.Create(&schedule).Error; err != nil {
+		return schedule, fmt.Errorf("Create %v", err)
+	}
+	return schedule, nil
+}
+
+// DeleteSchedule to remove a report schedule
+func (r *ReportManager) DeleteSchedule(id uint) error {
+	if err := r.DB.Unscoped().Delete(&ReportSchedule{}, id).Error; err != nil {
+		return fmt.Errorf("Delete %v", err)
+	}
+	return nil
+}
+
+// claimSchedule to move a due schedule to its next run, only one instance of the service gets it
+func (r *ReportManager) claimSchedule(schedule ReportSchedule, now time.Time) (bool, error) {
+	next, err := NextRun(schedule.Frequency, now)
+	if err != nil {
+		return false, err
+	}
+	res := r.DB.Model(&ReportSchedule{}).Where("id = ? AND next_run = ?", schedule.ID, schedule.NextRun).Updates(map[string]interface{}{
+		"last_run": now,
+		"next_run": next,
+	})
+	if res.Error != nil {
+		return false, fmt.Errorf("Updates %v", res.Error)
+	}
+	return res.RowsAffected == 1, nil
+}
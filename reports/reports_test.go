@@ -0,0 +1,99 @@
+package reports
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePeriod(t *testing.T) {
+	// Wednesday
+	now := time.Date(2022, time.March, 16, 10, 30, 0, 0, time.UTC)
+	start, end, err := ParsePeriod(PeriodLastWeek, now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2022, time.March, 7, 0, 0, 0, 0, time.UTC), start)
+	assert.Equal(t, time.Date(2022, time.March, 14, 0, 0, 0, 0, time.UTC), end)
+	start, end, err = ParsePeriod(PeriodLastMonth, now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2022, time.February, 1, 0, 0, 0, 0, time.UTC), start)
+	assert.Equal(t, time.Date(2022, time.March, 1, 0, 0, 0, 0, time.UTC), end)
+	_, _, err = ParsePeriod("yesterday", now)
+	assert.Error(t, err)
+}
+
+func TestParsePeriodMonday(t *testing.T) {
+	now := time.Date(2022, time.March, 14, 0, 0, 0, 0, time.UTC)
+	start, end, err := ParsePeriod(PeriodLastWeek, now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2022, time.March, 7, 0, 0, 0, 0, time.UTC), start)
+	assert.Equal(t, now, end)
+}
+
+func TestNextRun(t *testing.T) {
+	now := time.Date(2022, time.March, 16, 10, 30, 0, 0, time.UTC)
+	next, err := NextRun(FrequencyWeekly, now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2022, time.March, 21, 0, 0, 0, 0, time.UTC), next)
+	next, err = NextRun(FrequencyMonthly, now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2022, time.April, 1, 0, 0, 0, 0, time.UTC), next)
+	_, err = NextRun("daily", now)
+	assert.Error(t, err)
+}
+
+func TestRender(t *testing.T) {
+	top := make(chan QueryCount, 1)
+	top <- QueryCount{Name: "q1", Creator: "admin", Query: "SELECT * FROM osquery_info;", Executions: 5, Errors: 1}
+	close(top)
+	failed := make(chan QueryCount)
+	close(failed)
+	r := &ReportManager{}
+	var out bytes.Buffer
+	err := r.Render(&out, ReportData{
+		Title:         "osctrl report for dev",
+		Environment:   "dev",
+		Period:        PeriodLastWeek,
+		Nodes:         NodeCounts{Total: 4, Active: 3, Inactive: 1},
+		Platforms:     []PlatformCount{{Platform: "ubuntu", Nodes: 4}},
+		Attributes:    []AttributeCount{{Namespace: "cmdb", Nodes: 2}},
+		TopQueries:    top,
+		FailedQueries: failed,
+		Ingested:      []IngestedVolume{{Name: "status logs", Bytes: 2048}},
+		IngestedTotal: 2048,
+	})
+	assert.NoError(t, err)
+	html := out.String()
+	assert.Contains(t, html, "osctrl report for dev")
+	assert.Contains(t, html, "75.0%")
+	assert.Contains(t, html, "Custom attributes")
+	assert.Contains(t, html, "50.0%")
+	assert.Contains(t, html, "SELECT * FROM osquery_info;")
+	assert.Contains(t, html, "No query errors in this period")
+	assert.Contains(t, html, "2.0 KB")
+}
+
+func TestRenderLocale(t *testing.T) {
+	empty := make(chan QueryCount)
+	close(empty)
+	r := &ReportManager{}
+	var out bytes.Buffer
+	err := r.Render(&out, ReportData{
+		Title:         "Reporte de osctrl para dev",
+		Locale:        "es",
+		Environment:   "dev",
+		Period:        PeriodLastWeek,
+		Start:         time.Date(2022, time.March, 21, 0, 0, 0, 0, time.UTC),
+		End:           time.Date(2022, time.March, 28, 0, 0, 0, 0, time.UTC),
+		QueriesTotal:  3,
+		TopQueries:    empty,
+		FailedQueries: empty,
+	})
+	assert.NoError(t, err)
+	html := out.String()
+	assert.Contains(t, html, `<html lang="es">`)
+	assert.Contains(t, html, "21/03/2022")
+	assert.Contains(t, html, "Se ejecutaron 3 consultas en este periodo.")
+	assert.NotContains(t, html, "No query errors in this period")
+}
@@ -0,0 +1,59 @@
+package reports
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	// DownloadLinkExpiration in minutes to expire download links
+	DownloadLinkExpiration = 5
+)
+
+// ReportsS3 to store reports in S3
+type ReportsS3 struct {
+	Client   *s3.Client
+	Uploader *manager.Uploader
+	Bucket   string
+}
+
+// CreateReportsS3 to store reports in a bucket, reusing an existing client
+func CreateReportsS3(client *s3.Client, bucket string) *ReportsS3 {
+	return &ReportsS3{
+		Client:   client,
+		Uploader: manager.NewUploader(client),
+		Bucket:   bucket,
+	}
+}
+
+// Upload to stream a report to S3, in parts so it does not need to be in memory
+func (reportsS3 *ReportsS3) Upload(ctx context.Context, key string, body io.Reader, contentType string) error {
+	if _, err := reportsS3.Uploader.Upload(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(reportsS3.Bucket),
+		Key:         aws.String(key),
+		Body:        body,
+		ContentType: aws.String(contentType),
+	}); err != nil {
+		return fmt.Errorf("error sending report to s3 - %s", err)
+	}
+	return nil
+}
+
+// GetDownloadLink to generate a pre-signed link to download a report directly from s3
+func (reportsS3 *ReportsS3) GetDownloadLink(ctx context.Context, key string) (string, error) {
+	preClient := s3.NewPresignClient(reportsS3.Client)
+	lnk, err := preClient.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(reportsS3.Bucket),
+		Key:    aws.String(key),
+	}, s3.WithPresignExpires(DownloadLinkExpiration*time.Minute))
+	if err != nil {
+		return "", fmt.Errorf("PresignGetObject - %s", err)
+	}
+	return lnk.URL, nil
+}
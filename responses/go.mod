@@ -0,0 +1,20 @@
+module responses
+
+go 1.17
+
+replace github.com/jmpsec/osctrl/carves => ../carves
+
+replace github.com/jmpsec/osctrl/nodes => ../nodes
+
+replace github.com/jmpsec/osctrl/queries => ../queries
+
+replace github.com/jmpsec/osctrl/types => ../types
+
+require (
+	github.com/jmpsec/osctrl/carves v0.3.1
+	github.com/jmpsec/osctrl/nodes v0.3.1
+	github.com/jmpsec/osctrl/queries v0.3.1
+	github.com/jmpsec/osctrl/types v0.3.1
+	github.com/stretchr/testify v1.8.1
+	gorm.io/gorm v1.24.3
+)
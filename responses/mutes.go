@@ -0,0 +1,368 @@
+package responses
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// MuteResponses for mutes of response triggers, the rule is the name of the trigger
+	MuteResponses string = "responses"
+	// MuteIdentity for mutes of identity mismatch notifications
+	MuteIdentity string = "identity_mismatch"
+	// MuteStorage for mutes of storage quota notifications, only for whole environments
+	MuteStorage string = "storage_quota"
+)
+
+// MuteKinds to list all the kinds of notifications that can be muted
+var MuteKinds = []string{MuteResponses, MuteIdentity, MuteStorage}
+
+const (
+	// MaxMuteDuration is the longest a mute can last without being permanent
+	MaxMuteDuration = 30 * 24 * time.Hour
+	// MuteExpiredShown is how long expired mutes are still listed, with what they suppressed
+	MuteExpiredShown = 7 * 24 * time.Hour
+	// DefaultMuteExpiryInterval is how often expired mutes are checked to notify who set them
+	DefaultMuteExpiryInterval = time.Minute
+	// How long the mutes are kept in memory before reading them again from the DB
+	mutesCacheTTL = 60 * time.Second
+)
+
+// Mute to keep notifications of one kind from being delivered for a node or for a whole environment, until it expires.
+// Muted events are still recorded, and counted as suppressed by the mute.
+type Mute struct {
+	gorm.Model
+	EnvironmentID  uint `gorm:"index"`
+	NodeUUID       string
+	Kind           string
+	Rule           string
+	Reason         string
+	Creator        string
+	ExpiresAt      time.Time
+	Permanent      bool
+	NotifyExpiry   bool
+	Notified       bool
+	Suppressed     int64
+	LastSuppressed time.Time
+}
+
+// Active to check if a mute still applies
+func (m Mute) Active(now time.Time) bool {
+	return m.Permanent || now.Before(m.ExpiresAt)
+}
+
+// Matches to check if a mute applies to a notification of a kind and rule for a node.
+// Mutes without node apply to all the nodes of the environment, and mutes without rule to all the rules of the kind.
+func (m Mute) Matches(uuid, kind, rule string) bool {
+	if m.Kind != kind {
+		return false
+	}
+	if m.NodeUUID != "" && !strings.EqualFold(m.NodeUUID, uuid) {
+		return false
+	}
+	return m.Rule == "" || m.Rule == rule
+}
+
+// Scope to describe what a mute applies to
+func (m Mute) Scope() string {
+	scope := m.Kind
+	if m.Rule != "" {
+		scope += " " + m.Rule
+	}
+	if m.NodeUUID != "" {
+		return scope + " for node " + m.NodeUUID
+	}
+	return scope + " for the environment"
+}
+
+// Helper to check if a kind of notification can be muted
+func validMuteKind(kind string) bool {
+	for _, k := range MuteKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateMute to check a mute before saving it, timed mutes must expire in the future and within the maximum
+func ValidateMute(m Mute, now time.Time) error {
+	if !validMuteKind(m.Kind) {
+		return fmt.Errorf("invalid mute kind %q", m.Kind)
+	}
+	if strings.TrimSpace(m.Reason) == "" {
+		return fmt.Errorf("a reason is required")
+	}
+	if m.Kind == MuteStorage && m.NodeUUID != "" {
+		return fmt.Errorf("storage quota notifications can only be muted for the environment")
+	}
+	if m.Kind != MuteResponses && m.Rule != "" {
+		return fmt.Errorf("only response triggers are muted by rule")
+	}
+	if m.Rule != "" && !triggerName.MatchString(m.Rule) {
+		return fmt.Errorf("invalid trigger name %q", m.Rule)
+	}
+	if m.Permanent {
+		if !m.ExpiresAt.IsZero() {
+			return fmt.Errorf("permanent mutes do not expire")
+		}
+		return nil
+	}
+	if m.ExpiresAt.IsZero() {
+		return fmt.Errorf("an expiration is required, unless the mute is permanent")
+	}
+	if !m.ExpiresAt.After(now) {
+		return fmt.Errorf("expiration is not in the future")
+	}
+	if m.ExpiresAt.Sub(now) > MaxMuteDuration {
+		return fmt.Errorf("mutes can not last more than %d days unless permanent", int(MaxMuteDuration.Hours()/24))
+	}
+	return nil
+}
+
+// FindMute to get the mute that applies to a notification, among the mutes of one environment.
+// Mutes of the node go before mutes of the environment, so suppressed events are counted by the most specific one.
+func FindMute(mutes []Mute, uuid, kind, rule string, now time.Time) (Mute, bool) {
+	var found Mute
+	var ok bool
+	for _, m := range mutes {
+		if !m.Active(now) || !m.Matches(uuid, kind, rule) {
+			continue
+		}
+		if m.NodeUUID != "" {
+			return m, true
+		}
+		if !ok {
+			found, ok = m, true
+		}
+	}
+	return found, ok
+}
+
+// Leader to elect the instance that notifies expired mutes, like the leader locks of the backend
+type Leader interface {
+	Acquire() (bool, error)
+}
+
+// mutesCache to keep the mutes of environments in memory, so they are checked without reading the DB
+type mutesCache struct {
+	mux       sync.RWMutex
+	mutes     map[uint][]Mute
+	refreshed time.Time
+}
+
+// SetMute to save a new mute, refreshing the mutes in memory
+func (m *ResponseManager) SetMute(mute Mute, now time.Time) (Mute, error) {
+	mute.NodeUUID = strings.ToUpper(strings.TrimSpace(mute.NodeUUID))
+	mute.Reason = strings.TrimSpace(mute.Reason)
+	if err := ValidateMute(mute, now); err != nil {
+		return mute, err
+	}
+	if err := m.DB.Create(&mute).Error; err != nil {
+		return mute, err
+	}
+	if err := m.RefreshMutes(); err != nil {
+		log.Printf("error refreshing mutes %v", err)
+	}
+	return mute, nil
+}
+
+// GetMute to get one mute of an environment by id
+func (m *ResponseManager) GetMute(envid, id uint) (Mute, error) {
+	var mute Mute
+	if err := m.DB.Where("environment_id = ? AND id = ?", envid, id).First(&mute).Error; err != nil {
+		return mute, err
+	}
+	return mute, nil
+}
+
+// DeleteMute to lift one mute of an environment before it expires, refreshing the mutes in memory
+func (m *ResponseManager) DeleteMute(envid, id uint) (Mute, error) {
+	mute, err := m.GetMute(envid, id)
+	if err != nil {
+		return mute, err
+	}
+	if err := m.DB.Delete(&mute).Error; err != nil {
+		return mute, err
+	}
+	if err := m.RefreshMutes(); err != nil {
+		log.Printf("error refreshing mutes %v", err)
+	}
+	return mute, nil
+}
+
+// Mutes to get the active mutes of an environment, with the ones expired recently, newest first
+func (m *ResponseManager) Mutes(envid uint, now time.Time) ([]Mute, error) {
+	var mutes []Mute
+	err := m.DB.Where("environment_id = ? AND (permanent = ? OR expires_at > ?)", envid, true, now.Add(-MuteExpiredShown)).Order("created_at desc").Find(&mutes).Error
+	if err != nil {
+		return mutes, err
+	}
+	return mutes, nil
+}
+
+// NodeMutes to get the mutes that apply to one node, its own and the ones of its environment
+func NodeMutes(mutes []Mute, uuid string) []Mute {
+	var applied []Mute
+	for _, m := range mutes {
+		if m.NodeUUID == "" || strings.EqualFold(m.NodeUUID, uuid) {
+			applied = append(applied, m)
+		}
+	}
+	return applied
+}
+
+// SuppressedTally to get how many events were suppressed by a list of mutes
+func SuppressedTally(mutes []Mute) int64 {
+	var total int64
+	for _, m := range mutes {
+		total += m.Suppressed
+	}
+	return total
+}
+
+// RefreshMutes to read the active mutes of all environments into memory
+func (m *ResponseManager) RefreshMutes() error {
+	var mutes []Mute
+	if err := m.DB.Where("permanent = ? OR expires_at > ?", true, time.Now()).Find(&mutes).Error; err != nil {
+		return err
+	}
+	active := make(map[uint][]Mute)
+	for _, mute := range mutes {
+		active[mute.EnvironmentID] = append(active[mute.EnvironmentID], mute)
+	}
+	m.mutes.mux.Lock()
+	m.mutes.mutes = active
+	m.mutes.refreshed = time.Now()
+	m.mutes.mux.Unlock()
+	return nil
+}
+
+// Muted to check if a notification of a kind and rule for a node is muted, with the mutes in memory.
+// Suppressed events are counted by the mute that applies to them.
+func (m *ResponseManager) Muted(envid uint, uuid, kind, rule string) (Mute, bool) {
+	m.mutes.mux.RLock()
+	refreshed := m.mutes.refreshed
+	m.mutes.mux.RUnlock()
+	if time.Since(refreshed) > mutesCacheTTL {
+		if err := m.RefreshMutes(); err != nil {
+			log.Printf("error refreshing mutes %v", err)
+			return Mute{}, false
+		}
+	}
+	now := time.Now()
+	m.mutes.mux.RLock()
+	mute, ok := FindMute(m.mutes.mutes[envid], uuid, kind, rule, now)
+	m.mutes.mux.RUnlock()
+	if ok {
+		err := m.DB.Model(&Mute{}).Where("id = ?", mute.ID).Updates(map[string]interface{}{
+			"suppressed":      gorm.Expr("suppressed + 1"),
+			"last_suppressed": now,
+		}).Error
+		if err != nil {
+			log.Printf("error counting suppressed event %v", err)
+		}
+	}
+	return mute, ok
+}
+
+// ExpiredMutes to get the mutes that expired and must notify who set them
+func (m *ResponseManager) ExpiredMutes(now time.Time) ([]Mute, error) {
+	var mutes []Mute
+	if err := m.DB.Where("notify_expiry = ? AND notified = ? AND permanent = ? AND expires_at <= ?", true, false, false, now).Find(&mutes).Error; err != nil {
+		return mutes, err
+	}
+	return mutes, nil
+}
+
+// StartMuteExpiry to notify expired mutes periodically. Only the instance holding the leader lock notifies them,
+// and each mute is marked as notified before notify is called, so it is notified once.
+func (m *ResponseManager) StartMuteExpiry(ctx context.Context, leader Leader, interval time.Duration, notify func(Mute)) {
+	if interval <= 0 {
+		interval = DefaultMuteExpiryInterval
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				m.notifyExpired(leader, time.Now(), notify)
+			}
+		}
+	}()
+}
+
+// Helper to notify the mutes expired since the last check
+func (m *ResponseManager) notifyExpired(leader Leader, now time.Time, notify func(Mute)) {
+	if leader != nil {
+		isLeader, err := leader.Acquire()
+		if err != nil {
+			log.Printf("error acquiring leader lock %v", err)
+			return
+		}
+		if !isLeader {
+			return
+		}
+	}
+	expired, err := m.ExpiredMutes(now)
+	if err != nil {
+		log.Printf("error getting expired mutes %v", err)
+		return
+	}
+	for _, mute := range expired {
+		res := m.DB.Model(&Mute{}).Where("id = ? AND notified = ?", mute.ID, false).Update("notified", true)
+		if res.Error != nil {
+			log.Printf("error marking mute %d as notified %v", mute.ID, res.Error)
+			continue
+		}
+		if res.RowsAffected > 0 && notify != nil {
+			notify(mute)
+		}
+	}
+}
+
+// NotifyMuteExpired to send an expired mute as JSON to a webhook, with who set it so it can be routed to them.
+// Errors are only logged.
+func NotifyMuteExpired(webhook, environment string, mute Mute) {
+	if webhook == "" {
+		return
+	}
+	payload, err := json.Marshal(map[string]interface{}{
+		"event":       "mute_expired",
+		"environment": environment,
+		"scope":       mute.Scope(),
+		"kind":        mute.Kind,
+		"rule":        mute.Rule,
+		"node":        mute.NodeUUID,
+		"reason":      mute.Reason,
+		"creator":     mute.Creator,
+		"expired_at":  mute.ExpiresAt,
+		"suppressed":  mute.Suppressed,
+	})
+	if err != nil {
+		log.Printf("error serializing expired mute %v", err)
+		return
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(webhook, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		log.Printf("error notifying expired mute %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("error notifying expired mute, webhook returned %d", resp.StatusCode)
+	}
+}
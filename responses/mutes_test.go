@@ -0,0 +1,99 @@
+package responses
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateMute(t *testing.T) {
+	now := time.Date(2022, 3, 8, 14, 0, 0, 0, time.UTC)
+	valid := Mute{Kind: MuteResponses, Rule: "suspicious-process", Reason: "build server", ExpiresAt: now.Add(24 * time.Hour)}
+	assert.NoError(t, ValidateMute(valid, now))
+	// Reason is required
+	bad := valid
+	bad.Reason = " "
+	assert.Error(t, ValidateMute(bad, now))
+	// Unknown kind
+	bad = valid
+	bad.Kind = "email"
+	assert.Error(t, ValidateMute(bad, now))
+	// Rules only for response triggers
+	bad = valid
+	bad.Kind = MuteIdentity
+	assert.Error(t, ValidateMute(bad, now))
+	// Storage quotas only for the environment
+	bad = Mute{Kind: MuteStorage, NodeUUID: "UUID", Reason: "migration", ExpiresAt: now.Add(time.Hour)}
+	assert.Error(t, ValidateMute(bad, now))
+	// Expiry is mandatory, in the future and within the maximum
+	bad = valid
+	bad.ExpiresAt = time.Time{}
+	assert.Error(t, ValidateMute(bad, now))
+	bad.ExpiresAt = now
+	assert.Error(t, ValidateMute(bad, now))
+	bad.ExpiresAt = now.Add(MaxMuteDuration + time.Minute)
+	assert.Error(t, ValidateMute(bad, now))
+	// Permanent mutes do not expire
+	permanent := valid
+	permanent.ExpiresAt = time.Time{}
+	permanent.Permanent = true
+	assert.NoError(t, ValidateMute(permanent, now))
+	permanent.ExpiresAt = now.Add(time.Hour)
+	assert.Error(t, ValidateMute(permanent, now))
+}
+
+func TestMuteExpiry(t *testing.T) {
+	now := time.Date(2022, 3, 8, 14, 0, 0, 0, time.UTC)
+	mute := Mute{Kind: MuteIdentity, NodeUUID: "UUID", Reason: "hardware swap", ExpiresAt: now.Add(time.Hour)}
+	assert.True(t, mute.Active(now))
+	assert.False(t, mute.Active(now.Add(time.Hour)))
+	_, ok := FindMute([]Mute{mute}, "uuid", MuteIdentity, "", now.Add(30*time.Minute))
+	assert.True(t, ok)
+	_, ok = FindMute([]Mute{mute}, "uuid", MuteIdentity, "", now.Add(2*time.Hour))
+	assert.False(t, ok)
+	mute.Permanent = true
+	mute.ExpiresAt = time.Time{}
+	assert.True(t, mute.Active(now.Add(365*24*time.Hour)))
+}
+
+func TestFindMuteNodeAndEnvironment(t *testing.T) {
+	now := time.Date(2022, 3, 8, 14, 0, 0, 0, time.UTC)
+	env := Mute{Kind: MuteResponses, Rule: "evil", Reason: "noisy rule", ExpiresAt: now.Add(2 * time.Hour)}
+	env.ID = 1
+	node := Mute{Kind: MuteResponses, Rule: "evil", NodeUUID: "BUILD-01", Reason: "build server", ExpiresAt: now.Add(time.Hour)}
+	node.ID = 2
+	other := Mute{Kind: MuteResponses, NodeUUID: "BUILD-02", Reason: "all rules", ExpiresAt: now.Add(time.Hour)}
+	other.ID = 3
+	mutes := []Mute{env, node, other}
+	// The mute of the node counts the suppressed events of the node
+	found, ok := FindMute(mutes, "build-01", MuteResponses, "evil", now)
+	assert.True(t, ok)
+	assert.Equal(t, uint(2), found.ID)
+	// Other nodes are muted by the environment
+	found, ok = FindMute(mutes, "LAPTOP-01", MuteResponses, "evil", now)
+	assert.True(t, ok)
+	assert.Equal(t, uint(1), found.ID)
+	// Mutes without rule apply to all the rules of the node
+	found, ok = FindMute(mutes, "BUILD-02", MuteResponses, "other", now)
+	assert.True(t, ok)
+	assert.Equal(t, uint(3), found.ID)
+	_, ok = FindMute(mutes, "LAPTOP-01", MuteResponses, "other", now)
+	assert.False(t, ok)
+	// After the mute of the node expires, the environment mute still applies
+	found, ok = FindMute(mutes, "BUILD-01", MuteResponses, "evil", now.Add(90*time.Minute))
+	assert.True(t, ok)
+	assert.Equal(t, uint(1), found.ID)
+	// And nothing is muted after both expire
+	_, ok = FindMute(mutes, "BUILD-01", MuteResponses, "evil", now.Add(3*time.Hour))
+	assert.False(t, ok)
+	// Other kinds are not muted
+	_, ok = FindMute(mutes, "BUILD-01", MuteIdentity, "", now)
+	assert.False(t, ok)
+}
+
+func TestNodeMutes(t *testing.T) {
+	mutes := []Mute{{Kind: MuteStorage}, {Kind: MuteIdentity, NodeUUID: "BUILD-01"}, {Kind: MuteIdentity, NodeUUID: "BUILD-02"}}
+	assert.Len(t, NodeMutes(mutes, "build-01"), 2)
+	assert.Equal(t, int64(5), SuppressedTally([]Mute{{Suppressed: 2}, {Suppressed: 3}}))
+}
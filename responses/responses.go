@@ -0,0 +1,737 @@
+package responses
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmpsec/osctrl/carves"
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/jmpsec/osctrl/queries"
+	"github.com/jmpsec/osctrl/types"
+	"gorm.io/gorm"
+)
+
+const (
+	// KindQuery for actions that run a query template against the node
+	KindQuery string = "query"
+	// KindCarve for actions that carve a path rendered from the event
+	KindCarve string = "carve"
+)
+
+// Kinds to list all the kinds of response actions
+var Kinds = []string{KindQuery, KindCarve}
+
+const (
+	// StatusLaunched for actions that launched their query or carve
+	StatusLaunched string = "launched"
+	// StatusRateLimited for actions skipped because the trigger fired for the node within the cooldown
+	StatusRateLimited string = "rate_limited"
+	// StatusCircuitOpen for actions skipped because the environment hit the maximum actions per hour
+	StatusCircuitOpen string = "circuit_open"
+	// StatusNotAllowed for actions of a kind not allowed in the environment
+	StatusNotAllowed string = "not_allowed"
+	// StatusDisabled for actions in environments without response actions enabled
+	StatusDisabled string = "disabled"
+	// StatusFailed for actions that could not be launched
+	StatusFailed string = "failed"
+	// StatusMuted for actions not launched because the trigger is muted for the node
+	StatusMuted string = "muted"
+)
+
+const (
+	// SourceResults for actions fired by rows of scheduled query results
+	SourceResults string = "results"
+	// SourceAlert for actions fired by external alerts sent to the API
+	SourceAlert string = "alert"
+)
+
+const (
+	// DefaultCooldown is the default seconds before a trigger fires again for the same node
+	DefaultCooldown = 3600
+	// DefaultMaxPerHour is the default maximum of actions launched per hour in one environment
+	DefaultMaxPerHour = 20
+	// Prefix for the creator of queries and carves launched by response actions
+	creatorPrefix = "response:"
+	// How long the active triggers are cached before reading them again from the DB
+	triggersCacheTTL = 60 * time.Second
+)
+
+// Placeholders of event fields in paths and variables, as {{name}}
+var fieldPlaceholder = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+// Valid names of triggers
+var triggerName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// ResponseConfig to enable response actions in one environment, with the allowed kinds as a comma separated list.
+// The circuit breaker trips when the environment reaches the maximum actions per hour, and stays open until reset.
+type ResponseConfig struct {
+	gorm.Model
+	EnvironmentID uint `gorm:"index"`
+	Enabled       bool
+	Allowed       string
+	Cooldown      int
+	MaxPerHour    int
+	TrippedAt     time.Time
+}
+
+// ResponseTrigger to define the action to take when a row of a scheduled query matches, or an external alert is received.
+// Variables of the query template are stored as JSON, with values rendered from the fields of the event.
+type ResponseTrigger struct {
+	gorm.Model
+	EnvironmentID uint `gorm:"index"`
+	Name          string
+	Query         string
+	Column        string
+	Value         string
+	Kind          string
+	Template      string
+	Variables     string
+	Path          string
+	Enabled       bool
+	Creator       string
+}
+
+// ResponseAction to record every action fired by a trigger, with the event that fired it
+type ResponseAction struct {
+	gorm.Model
+	EnvironmentID uint `gorm:"index"`
+	TriggerID     uint
+	Trigger       string
+	NodeUUID      string `gorm:"index"`
+	Kind          string
+	Source        string
+	Status        string
+	Target        string
+	QueryName     string
+	Message       string
+	Event         string
+}
+
+// EnvResponses to show the response actions configuration and the triggers of one environment
+type EnvResponses struct {
+	Config   ResponseConfig    `json:"config"`
+	Triggers []ResponseTrigger `json:"triggers"`
+}
+
+// triggersCache to keep the active triggers of environments in memory between DB reads
+type triggersCache struct {
+	mux      sync.Mutex
+	triggers map[uint][]ResponseTrigger
+	expires  time.Time
+}
+
+// ResponseManager to handle response actions
+type ResponseManager struct {
+	DB      *gorm.DB
+	Queries *queries.Queries
+	fire    sync.Mutex
+	cache   triggersCache
+	mutes   mutesCache
+	// OnFire is called with every recorded action that was not muted, if set
+	OnFire func(ResponseAction)
+}
+
+// CreateResponseManager to initialize the response actions struct and tables
+func CreateResponseManager(backend *gorm.DB, q *queries.Queries) *ResponseManager {
+	var m *ResponseManager = &ResponseManager{DB: backend, Queries: q}
+	// table response_configs
+	if err := backend.AutoMigrate(&ResponseConfig{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (response_configs): %v", err)
+	}
+	// table response_triggers
+	if err := backend.AutoMigrate(&ResponseTrigger{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (response_triggers): %v", err)
+	}
+	// table response_actions
+	if err := backend.AutoMigrate(&ResponseAction{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (response_actions): %v", err)
+	}
+	// table mutes
+	if err := backend.AutoMigrate(&Mute{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (mutes): %v", err)
+	}
+	return m
+}
+
+// DefaultConfig to get the configuration of environments that never enabled response actions
+func DefaultConfig(envid uint) ResponseConfig {
+	return ResponseConfig{
+		EnvironmentID: envid,
+		Cooldown:      DefaultCooldown,
+		MaxPerHour:    DefaultMaxPerHour,
+	}
+}
+
+// AllowedKinds to get the kinds of actions allowed by a configuration
+func AllowedKinds(cfg ResponseConfig) []string {
+	var allowed []string
+	for _, k := range strings.Split(cfg.Allowed, ",") {
+		k = strings.TrimSpace(k)
+		if k != "" {
+			allowed = append(allowed, k)
+		}
+	}
+	return allowed
+}
+
+// IsAllowed to check if a kind of action is allowed by a configuration
+func IsAllowed(cfg ResponseConfig, kind string) bool {
+	for _, k := range AllowedKinds(cfg) {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper to check if a kind of action is valid
+func validKind(kind string) bool {
+	for _, k := range Kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateConfig to check the configuration of response actions before saving it
+func ValidateConfig(cfg ResponseConfig) error {
+	for _, k := range AllowedKinds(cfg) {
+		if !validKind(k) {
+			return fmt.Errorf("invalid action kind %s", k)
+		}
+	}
+	if cfg.Cooldown < 0 {
+		return fmt.Errorf("cooldown can not be negative")
+	}
+	if cfg.MaxPerHour <= 0 {
+		return fmt.Errorf("maximum actions per hour must be positive")
+	}
+	return nil
+}
+
+// EncodeVariables to store the variables of the query template of a trigger
+func EncodeVariables(vars map[string]string) string {
+	if len(vars) == 0 {
+		return ""
+	}
+	b, err := json.Marshal(vars)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// TriggerVariables to get the variables of the query template of a trigger
+func TriggerVariables(t ResponseTrigger) (map[string]string, error) {
+	vars := make(map[string]string)
+	if t.Variables == "" {
+		return vars, nil
+	}
+	if err := json.Unmarshal([]byte(t.Variables), &vars); err != nil {
+		return vars, fmt.Errorf("invalid variables - %v", err)
+	}
+	return vars, nil
+}
+
+// ValidateTrigger to check a trigger before saving it
+func ValidateTrigger(t ResponseTrigger) error {
+	if !triggerName.MatchString(t.Name) {
+		return fmt.Errorf("invalid trigger name %q", t.Name)
+	}
+	if (t.Column == "") != (t.Value == "") {
+		return fmt.Errorf("column and value must be set together")
+	}
+	if t.Column != "" && t.Query == "" {
+		return fmt.Errorf("column filters need a query")
+	}
+	switch t.Kind {
+	case KindQuery:
+		if t.Template == "" {
+			return fmt.Errorf("query actions need a template")
+		}
+		if t.Path != "" {
+			return fmt.Errorf("query actions do not use a path")
+		}
+		if _, err := TriggerVariables(t); err != nil {
+			return err
+		}
+	case KindCarve:
+		if t.Path == "" {
+			return fmt.Errorf("carve actions need a path")
+		}
+		if t.Template != "" || t.Variables != "" {
+			return fmt.Errorf("carve actions do not use a template")
+		}
+	default:
+		return fmt.Errorf("invalid action kind %q", t.Kind)
+	}
+	return nil
+}
+
+// RenderFields to replace the {{name}} placeholders of a value with the fields of an event, all of them are required
+func RenderFields(value string, fields map[string]string) (string, error) {
+	var missing []string
+	rendered := fieldPlaceholder.ReplaceAllStringFunc(value, func(m string) string {
+		name := fieldPlaceholder.FindStringSubmatch(m)[1]
+		v, ok := fields[name]
+		if !ok {
+			missing = append(missing, name)
+		}
+		return v
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("missing event fields %s", strings.Join(missing, ", "))
+	}
+	return rendered, nil
+}
+
+// RenderPath to get the path to carve from the path of a trigger and the fields of an event.
+// Paths come from nodes, so quotes and control characters are rejected to keep the carve query intact.
+func RenderPath(path string, fields map[string]string) (string, error) {
+	rendered, err := RenderFields(path, fields)
+	if err != nil {
+		return "", err
+	}
+	rendered = strings.TrimSpace(rendered)
+	if rendered == "" {
+		return "", fmt.Errorf("empty path")
+	}
+	for _, c := range rendered {
+		if c == '\'' || c < 0x20 || c == 0x7f {
+			return "", fmt.Errorf("invalid character in path %q", rendered)
+		}
+	}
+	return rendered, nil
+}
+
+// TemplateValues to get the values for the variables of a query template from the fields of an event.
+// Variables of the trigger are rendered with the fields, the rest get the field with the same name.
+func TemplateValues(vars []queries.TemplateVariable, variables, fields map[string]string) (map[string]string, error) {
+	values := make(map[string]string, len(vars))
+	for _, v := range vars {
+		if tpl, ok := variables[v.Name]; ok {
+			value, err := RenderFields(tpl, fields)
+			if err != nil {
+				return values, fmt.Errorf("variable %s - %v", v.Name, err)
+			}
+			values[v.Name] = value
+		} else if value, ok := fields[v.Name]; ok {
+			values[v.Name] = value
+		}
+	}
+	return values, nil
+}
+
+// MatchEvent to check if a row of results fires a trigger, only rows added or in snapshots fire
+func MatchEvent(t ResponseTrigger, e types.ResultEvent) bool {
+	if !t.Enabled || t.Query == "" || t.Query != e.Name {
+		return false
+	}
+	if e.Action != types.ResultActionAdded && e.Action != types.ResultActionSnapshot {
+		return false
+	}
+	if t.Column != "" && e.Columns[t.Column] != t.Value {
+		return false
+	}
+	return true
+}
+
+// CheckLimits to get the status of an action that is about to be launched, empty if it can be launched.
+// It returns true when the action trips the circuit breaker.
+func CheckLimits(cfg ResponseConfig, kind string, launchedLastHour int64, lastForNode, now time.Time) (string, bool) {
+	if !cfg.Enabled {
+		return StatusDisabled, false
+	}
+	if !IsAllowed(cfg, kind) {
+		return StatusNotAllowed, false
+	}
+	if !cfg.TrippedAt.IsZero() {
+		return StatusCircuitOpen, false
+	}
+	if launchedLastHour >= int64(cfg.MaxPerHour) {
+		return StatusCircuitOpen, true
+	}
+	if !lastForNode.IsZero() && now.Sub(lastForNode) < time.Duration(cfg.Cooldown)*time.Second {
+		return StatusRateLimited, false
+	}
+	return "", false
+}
+
+// Helper to serialize the fields of an event for the record of an action
+func serializeEvent(fields map[string]string) string {
+	b, err := json.Marshal(fields)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// Config to get the response actions configuration of an environment
+func (m *ResponseManager) Config(envid uint) (ResponseConfig, error) {
+	var cfg ResponseConfig
+	if err := m.DB.Where("environment_id = ?", envid).First(&cfg).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return DefaultConfig(envid), nil
+		}
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// SetConfig to save the response actions configuration of an environment, keeping the state of the circuit breaker
+func (m *ResponseManager) SetConfig(envid uint, cfg ResponseConfig) (ResponseConfig, error) {
+	if err := ValidateConfig(cfg); err != nil {
+		return cfg, err
+	}
+	current, err := m.Config(envid)
+	if err != nil {
+		return cfg, err
+	}
+	current.EnvironmentID = envid
+	current.Enabled = cfg.Enabled
+	current.Allowed = strings.Join(AllowedKinds(cfg), ",")
+	current.Cooldown = cfg.Cooldown
+	current.MaxPerHour = cfg.MaxPerHour
+	if err := m.DB.Save(&current).Error; err != nil {
+		return current, err
+	}
+	m.invalidate()
+	return current, nil
+}
+
+// ResetCircuit to close the circuit breaker of an environment, so actions are launched again
+func (m *ResponseManager) ResetCircuit(envid uint) error {
+	return m.DB.Model(&ResponseConfig{}).Where("environment_id = ?", envid).Update("tripped_at", time.Time{}).Error
+}
+
+// Triggers to get all the triggers of an environment
+func (m *ResponseManager) Triggers(envid uint) ([]ResponseTrigger, error) {
+	var triggers []ResponseTrigger
+	if err := m.DB.Where("environment_id = ?", envid).Order("name").Find(&triggers).Error; err != nil {
+		return triggers, err
+	}
+	return triggers, nil
+}
+
+// GetTrigger to get one trigger of an environment by name
+func (m *ResponseManager) GetTrigger(envid uint, name string) (ResponseTrigger, error) {
+	var t ResponseTrigger
+	if err := m.DB.Where("environment_id = ? AND name = ?", envid, name).First(&t).Error; err != nil {
+		return t, err
+	}
+	return t, nil
+}
+
+// SetTrigger to create a trigger in an environment, or update the one with the same name
+func (m *ResponseManager) SetTrigger(envid uint, t ResponseTrigger) (ResponseTrigger, error) {
+	if err := ValidateTrigger(t); err != nil {
+		return t, err
+	}
+	existing, err := m.GetTrigger(envid, t.Name)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return t, err
+	}
+	t.Model = existing.Model
+	t.EnvironmentID = envid
+	if existing.ID != 0 && t.Creator == "" {
+		t.Creator = existing.Creator
+	}
+	if err := m.DB.Save(&t).Error; err != nil {
+		return t, err
+	}
+	m.invalidate()
+	return t, nil
+}
+
+// DeleteTrigger to delete one trigger of an environment, recorded actions are kept
+func (m *ResponseManager) DeleteTrigger(envid uint, name string) error {
+	t, err := m.GetTrigger(envid, name)
+	if err != nil {
+		return err
+	}
+	if err := m.DB.Unscoped().Delete(&t).Error; err != nil {
+		return err
+	}
+	m.invalidate()
+	return nil
+}
+
+// Actions to get the latest recorded actions of an environment, all of them with zero limit
+func (m *ResponseManager) Actions(envid uint, limit int) ([]ResponseAction, error) {
+	var actions []ResponseAction
+	tx := m.DB.Where("environment_id = ?", envid).Order("created_at desc")
+	if limit > 0 {
+		tx = tx.Limit(limit)
+	}
+	if err := tx.Find(&actions).Error; err != nil {
+		return actions, err
+	}
+	return actions, nil
+}
+
+// Helper to discard the cached triggers, after any change
+func (m *ResponseManager) invalidate() {
+	m.cache.mux.Lock()
+	m.cache.expires = time.Time{}
+	m.cache.mux.Unlock()
+}
+
+// ActiveTriggers to get the enabled triggers for results of environments with response actions enabled, cached
+func (m *ResponseManager) ActiveTriggers(envid uint) ([]ResponseTrigger, error) {
+	m.cache.mux.Lock()
+	defer m.cache.mux.Unlock()
+	if time.Now().Before(m.cache.expires) {
+		return m.cache.triggers[envid], nil
+	}
+	var configs []ResponseConfig
+	if err := m.DB.Where("enabled = ?", true).Find(&configs).Error; err != nil {
+		return nil, err
+	}
+	active := make(map[uint][]ResponseTrigger)
+	for _, cfg := range configs {
+		var triggers []ResponseTrigger
+		if err := m.DB.Where("environment_id = ? AND enabled = ? AND query <> ''", cfg.EnvironmentID, true).Find(&triggers).Error; err != nil {
+			return nil, err
+		}
+		if len(triggers) > 0 {
+			active[cfg.EnvironmentID] = triggers
+		}
+	}
+	m.cache.triggers = active
+	m.cache.expires = time.Now().Add(triggersCacheTTL)
+	return active[envid], nil
+}
+
+// Fire to take the action of a trigger for a node, checking the limits of the environment.
+// The action is always recorded, with the status and the event that fired it.
+func (m *ResponseManager) Fire(t ResponseTrigger, node nodes.OsqueryNode, fields map[string]string, source string) (ResponseAction, error) {
+	// Actions of one instance are serialized, so limits are checked against launched actions
+	m.fire.Lock()
+	defer m.fire.Unlock()
+	action := ResponseAction{
+		EnvironmentID: t.EnvironmentID,
+		TriggerID:     t.ID,
+		Trigger:       t.Name,
+		NodeUUID:      node.UUID,
+		Kind:          t.Kind,
+		Source:        source,
+		Event:         serializeEvent(fields),
+	}
+	cfg, err := m.Config(t.EnvironmentID)
+	if err != nil {
+		return action, err
+	}
+	now := time.Now()
+	var launched int64
+	if err := m.DB.Model(&ResponseAction{}).Where("environment_id = ? AND status = ? AND created_at > ?", t.EnvironmentID, StatusLaunched, now.Add(-time.Hour)).Count(&launched).Error; err != nil {
+		return action, err
+	}
+	var last ResponseAction
+	var lastForNode time.Time
+	if err := m.DB.Where("trigger_id = ? AND node_uuid = ? AND status = ?", t.ID, node.UUID, StatusLaunched).Order("created_at desc").First(&last).Error; err == nil {
+		lastForNode = last.CreatedAt
+	}
+	status, trip := CheckLimits(cfg, t.Kind, launched, lastForNode, now)
+	// Muted triggers are recorded without launching anything, and do not count for the limits
+	if status == "" || trip {
+		if mute, ok := m.Muted(t.EnvironmentID, node.UUID, MuteResponses, t.Name); ok {
+			status, trip = StatusMuted, false
+			action.Message = fmt.Sprintf("muted by %s: %s", mute.Creator, mute.Reason)
+		}
+	}
+	if trip {
+		if err := m.DB.Model(&ResponseConfig{}).Where("environment_id = ?", t.EnvironmentID).Update("tripped_at", now).Error; err != nil {
+			log.Printf("error tripping circuit breaker %v", err)
+		}
+		action.Message = fmt.Sprintf("%d actions launched in the last hour, circuit breaker tripped", launched)
+	}
+	if status == "" {
+		name, target, err := m.launch(t, node, fields)
+		action.Target = target
+		if err != nil {
+			status = StatusFailed
+			action.Message = err.Error()
+		} else {
+			status = StatusLaunched
+			action.QueryName = name
+		}
+	}
+	action.Status = status
+	if err := m.DB.Create(&action).Error; err != nil {
+		return action, err
+	}
+	if m.OnFire != nil && status != StatusMuted {
+		m.OnFire(action)
+	}
+	return action, nil
+}
+
+// Helper to launch the query or carve of a trigger for one node, returning the name and what was launched
+func (m *ResponseManager) launch(t ResponseTrigger, node nodes.OsqueryNode, fields map[string]string) (string, string, error) {
+	newQuery := queries.DistributedQuery{
+		Creator:       creatorPrefix + t.Name,
+		Active:        true,
+		EnvironmentID: t.EnvironmentID,
+	}
+	var target string
+	switch t.Kind {
+	case KindQuery:
+		target = t.Template
+		tpl, err := m.Queries.GetTemplate(t.Template)
+		if err != nil {
+			return "", target, fmt.Errorf("error getting template %s - %v", t.Template, err)
+		}
+		if !tpl.SupportsPlatform(node.Platform) {
+			return "", target, fmt.Errorf("template %s does not support %s", t.Template, node.Platform)
+		}
+		vars, err := tpl.Vars()
+		if err != nil {
+			return "", target, err
+		}
+		variables, err := TriggerVariables(t)
+		if err != nil {
+			return "", target, err
+		}
+		values, err := TemplateValues(vars, variables, fields)
+		if err != nil {
+			return "", target, err
+		}
+		newQuery.Name = queries.GenQueryName()
+		newQuery.Type = queries.StandardQueryType
+		if err := queries.ApplyTemplate(&newQuery, tpl, values); err != nil {
+			return "", target, err
+		}
+	case KindCarve:
+		path, err := RenderPath(t.Path, fields)
+		if err != nil {
+			return "", t.Path, err
+		}
+		target = path
+		newQuery.Name = carves.GenCarveName()
+		newQuery.Type = queries.CarveQueryType
+		newQuery.Query = carves.GenCarveQuery(path, false)
+		newQuery.Path = path
+	default:
+		return "", target, fmt.Errorf("invalid action kind %s", t.Kind)
+	}
+	if err := m.Queries.Create(newQuery); err != nil {
+		return "", target, fmt.Errorf("error creating query - %v", err)
+	}
+	if err := m.Queries.CreateTarget(newQuery.Name, queries.QueryTargetUUID, node.UUID); err != nil {
+		return "", target, fmt.Errorf("error creating query target - %v", err)
+	}
+	if err := m.Queries.SetExpected(newQuery.Name, 1, t.EnvironmentID); err != nil {
+		return "", target, fmt.Errorf("error setting expected - %v", err)
+	}
+	if _, err := m.Queries.Materialize(newQuery.Name, t.EnvironmentID); err != nil {
+		return "", target, fmt.Errorf("error materializing query - %v", err)
+	}
+	return newQuery.Name, target, nil
+}
+
+// ProcessResults to fire the triggers matching the rows of result logs sent by a node.
+// Each trigger fires at most once per node for the same logs, so one batch does not flood the records.
+func (m *ResponseManager) ProcessResults(node nodes.OsqueryNode, data []byte) []ResponseAction {
+	var fired []ResponseAction
+	triggers, err := m.ActiveTriggers(node.EnvironmentID)
+	if err != nil {
+		log.Printf("error getting response triggers %v", err)
+		return fired
+	}
+	if len(triggers) == 0 {
+		return fired
+	}
+	byQuery := make(map[string][]ResponseTrigger)
+	for _, t := range triggers {
+		byQuery[t.Query] = append(byQuery[t.Query], t)
+	}
+	logs, err := types.ParseResultLogs(data)
+	if err != nil {
+		log.Printf("error parsing result logs for responses %v", err)
+		return fired
+	}
+	done := make(map[uint]bool)
+	for _, l := range logs {
+		candidates, ok := byQuery[l.Name]
+		if !ok {
+			continue
+		}
+		events, err := l.Events()
+		if err != nil {
+			log.Printf("error getting result events for responses %v", err)
+		}
+		for _, e := range events {
+			for _, t := range candidates {
+				if done[t.ID] || !MatchEvent(t, e) {
+					continue
+				}
+				done[t.ID] = true
+				action, err := m.Fire(t, node, e.Columns, SourceResults)
+				if err != nil {
+					log.Printf("error firing response trigger %s %v", t.Name, err)
+					continue
+				}
+				fired = append(fired, action)
+			}
+		}
+	}
+	return fired
+}
+
+// SerializeTriggers to record the triggers of an environment in the activity, sorted by name
+func SerializeTriggers(triggers []ResponseTrigger) string {
+	type entry struct {
+		Name      string `json:"name"`
+		Query     string `json:"query,omitempty"`
+		Column    string `json:"column,omitempty"`
+		Value     string `json:"value,omitempty"`
+		Kind      string `json:"kind"`
+		Template  string `json:"template,omitempty"`
+		Variables string `json:"variables,omitempty"`
+		Path      string `json:"path,omitempty"`
+		Enabled   bool   `json:"enabled"`
+	}
+	entries := make([]entry, 0, len(triggers))
+	for _, t := range triggers {
+		entries = append(entries, entry{
+			Name:      t.Name,
+			Query:     t.Query,
+			Column:    t.Column,
+			Value:     t.Value,
+			Kind:      t.Kind,
+			Template:  t.Template,
+			Variables: t.Variables,
+			Path:      t.Path,
+			Enabled:   t.Enabled,
+		})
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+	b, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// SerializeConfig to record the configuration of response actions of an environment in the activity
+func SerializeConfig(cfg ResponseConfig) string {
+	b, err := json.MarshalIndent(map[string]interface{}{
+		"enabled":      cfg.Enabled,
+		"allowed":      AllowedKinds(cfg),
+		"cooldown":     cfg.Cooldown,
+		"max_per_hour": cfg.MaxPerHour,
+	}, "", "  ")
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
@@ -0,0 +1,135 @@
+package responses
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jmpsec/osctrl/queries"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateTrigger(t *testing.T) {
+	valid := ResponseTrigger{Name: "suspicious-process", Query: "processes", Kind: KindCarve, Path: "{{path}}"}
+	assert.NoError(t, ValidateTrigger(valid))
+	query := ResponseTrigger{Name: "hashes", Kind: KindQuery, Template: "file_hash", Variables: `{"path":"{{path}}"}`}
+	assert.NoError(t, ValidateTrigger(query))
+	// Invalid name
+	bad := valid
+	bad.Name = "with spaces"
+	assert.Error(t, ValidateTrigger(bad))
+	// Column without value
+	bad = valid
+	bad.Column = "name"
+	assert.Error(t, ValidateTrigger(bad))
+	// Carve without path
+	bad = valid
+	bad.Path = ""
+	assert.Error(t, ValidateTrigger(bad))
+	// Query without template
+	bad = query
+	bad.Template = ""
+	assert.Error(t, ValidateTrigger(bad))
+	// Invalid variables
+	bad = query
+	bad.Variables = "{"
+	assert.Error(t, ValidateTrigger(bad))
+	// Unknown kind
+	bad = valid
+	bad.Kind = "isolate"
+	assert.Error(t, ValidateTrigger(bad))
+}
+
+func TestRenderPath(t *testing.T) {
+	fields := map[string]string{"path": "/tmp/evil", "name": "evil"}
+	path, err := RenderPath("{{path}}", fields)
+	assert.NoError(t, err)
+	assert.Equal(t, "/tmp/evil", path)
+	path, err = RenderPath("/home/{{ name }}/.bash_history", fields)
+	assert.NoError(t, err)
+	assert.Equal(t, "/home/evil/.bash_history", path)
+	// Missing field
+	_, err = RenderPath("{{cmdline}}", fields)
+	assert.Error(t, err)
+	// Empty path
+	_, err = RenderPath("{{path}}", map[string]string{"path": " "})
+	assert.Error(t, err)
+	// Quotes and control characters
+	_, err = RenderPath("{{path}}", map[string]string{"path": "/tmp/x' OR 1=1"})
+	assert.Error(t, err)
+	_, err = RenderPath("{{path}}", map[string]string{"path": "/tmp/x\ny"})
+	assert.Error(t, err)
+}
+
+func TestTemplateValues(t *testing.T) {
+	vars := []queries.TemplateVariable{{Name: "path"}, {Name: "pid"}, {Name: "user"}}
+	fields := map[string]string{"path": "/tmp/evil", "pid": "42"}
+	values, err := TemplateValues(vars, map[string]string{"path": "{{path}}/child"}, fields)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"path": "/tmp/evil/child", "pid": "42"}, values)
+	_, err = TemplateValues(vars, map[string]string{"user": "{{username}}"}, fields)
+	assert.Error(t, err)
+}
+
+func TestMatchEvent(t *testing.T) {
+	trigger := ResponseTrigger{Name: "evil", Query: "processes", Column: "name", Value: "evil", Kind: KindCarve, Path: "{{path}}", Enabled: true}
+	event := types.ResultEvent{Name: "processes", Action: types.ResultActionAdded, Columns: map[string]string{"name": "evil"}}
+	assert.True(t, MatchEvent(trigger, event))
+	snapshot := event
+	snapshot.Action = types.ResultActionSnapshot
+	assert.True(t, MatchEvent(trigger, snapshot))
+	removed := event
+	removed.Action = types.ResultActionRemoved
+	assert.False(t, MatchEvent(trigger, removed))
+	other := event
+	other.Columns = map[string]string{"name": "good"}
+	assert.False(t, MatchEvent(trigger, other))
+	other = event
+	other.Name = "users"
+	assert.False(t, MatchEvent(trigger, other))
+	disabled := trigger
+	disabled.Enabled = false
+	assert.False(t, MatchEvent(disabled, event))
+	alerts := trigger
+	alerts.Query = ""
+	assert.False(t, MatchEvent(alerts, types.ResultEvent{Action: types.ResultActionAdded}))
+}
+
+func TestCheckLimits(t *testing.T) {
+	now := time.Now()
+	cfg := ResponseConfig{Enabled: true, Allowed: "carve", Cooldown: 3600, MaxPerHour: 2}
+	status, trip := CheckLimits(cfg, KindCarve, 0, time.Time{}, now)
+	assert.Equal(t, "", status)
+	assert.False(t, trip)
+	// Kind not allowed
+	status, _ = CheckLimits(cfg, KindQuery, 0, time.Time{}, now)
+	assert.Equal(t, StatusNotAllowed, status)
+	// Disabled
+	disabled := cfg
+	disabled.Enabled = false
+	status, _ = CheckLimits(disabled, KindCarve, 0, time.Time{}, now)
+	assert.Equal(t, StatusDisabled, status)
+	// Cooldown for the node
+	status, _ = CheckLimits(cfg, KindCarve, 1, now.Add(-time.Minute), now)
+	assert.Equal(t, StatusRateLimited, status)
+	status, _ = CheckLimits(cfg, KindCarve, 1, now.Add(-2*time.Hour), now)
+	assert.Equal(t, "", status)
+	// Maximum per hour trips the breaker
+	status, trip = CheckLimits(cfg, KindCarve, 2, time.Time{}, now)
+	assert.Equal(t, StatusCircuitOpen, status)
+	assert.True(t, trip)
+	// Tripped breaker stays open until reset
+	tripped := cfg
+	tripped.TrippedAt = now.Add(-2 * time.Hour)
+	status, trip = CheckLimits(tripped, KindCarve, 0, time.Time{}, now)
+	assert.Equal(t, StatusCircuitOpen, status)
+	assert.False(t, trip)
+}
+
+func TestValidateConfig(t *testing.T) {
+	assert.NoError(t, ValidateConfig(ResponseConfig{Allowed: "query, carve", MaxPerHour: 1}))
+	assert.Error(t, ValidateConfig(ResponseConfig{Allowed: "isolate", MaxPerHour: 1}))
+	assert.Error(t, ValidateConfig(ResponseConfig{MaxPerHour: 0}))
+	assert.Error(t, ValidateConfig(ResponseConfig{Cooldown: -1, MaxPerHour: 1}))
+	assert.Equal(t, []string{"query", "carve"}, AllowedKinds(ResponseConfig{Allowed: "query, carve,"}))
+}
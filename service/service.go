@@ -0,0 +1,181 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jmpsec/osctrl/backend"
+	"github.com/jmpsec/osctrl/cache"
+	"github.com/jmpsec/osctrl/carves"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/logging"
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/jmpsec/osctrl/queries"
+	"github.com/jmpsec/osctrl/responses"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/tags"
+	"github.com/jmpsec/osctrl/webhooks"
+)
+
+const (
+	// BackendWait is the time to wait for the backend to be ready
+	BackendWait = 7 * time.Second
+)
+
+// Shared to hold the managers of osctrl services, created once when osctrl-tls, osctrl-admin and osctrl-api
+// run in the same process, so they use the same connections to the backend and the cache
+type Shared struct {
+	DB          *backend.DBManager
+	Redis       *cache.RedisManager
+	Settings    *settings.Settings
+	Envs        *environments.Environment
+	Nodes       *nodes.NodeManager
+	Queries     *queries.Queries
+	Tags        *tags.TagManager
+	Carves      *carves.Carves
+	Responses   *responses.ResponseManager
+	DeadLetters *logging.DeadLetters
+	Webhooks    *webhooks.WebhookManager
+}
+
+// Connect to the backend, waiting until it is ready, and to the cache
+func Connect(dbConfig backend.JSONConfigurationDB, redisConfig cache.JSONConfigurationRedis) (*backend.DBManager, *cache.RedisManager, error) {
+	log.Println("Initializing backend...")
+	var db *backend.DBManager
+	var err error
+	for {
+		db, err = backend.CreateDBManager(dbConfig)
+		if db != nil {
+			log.Println("Connection to backend successful!")
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to connect to backend - %v", err)
+		}
+		log.Println("Backend NOT ready! waiting...")
+		time.Sleep(BackendWait)
+	}
+	log.Println("Initializing cache...")
+	redis, err := cache.CreateRedisManager(redisConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to connect to redis - %v", err)
+	}
+	log.Println("Connection to cache successful!")
+	return db, redis, nil
+}
+
+// CreateShared to connect to the backend and the cache and initialize the managers on top of them
+func CreateShared(dbConfig backend.JSONConfigurationDB, redisConfig cache.JSONConfigurationRedis, carver string, s3 *carves.CarverS3) (*Shared, error) {
+	db, redis, err := Connect(dbConfig, redisConfig)
+	if err != nil {
+		return nil, err
+	}
+	shared := &Shared{DB: db, Redis: redis}
+	log.Println("Initialize environments")
+	shared.Envs = environments.CreateEnvironment(db.Conn)
+	shared.Envs.StartAccessLog(environments.DefaultAccessQueueSize)
+	log.Println("Initialize settings")
+	shared.Settings = settings.NewSettings(db.Conn)
+	log.Println("Initialize nodes")
+	shared.Nodes = nodes.CreateNodes(db.Conn)
+	shared.Nodes.Naming = func(environment string) nodes.NamingPolicy {
+		env, err := shared.Envs.Get(environment)
+		if err != nil {
+			log.Printf("error getting naming policy of %s %v", environment, err)
+			return nodes.NamingPolicy{}
+		}
+		return nodes.NewNamingPolicy(env.NamingLowercase, env.NamingDomains, env.NamingPreferFQDN)
+	}
+	log.Println("Initialize tags")
+	shared.Tags = tags.CreateTagManager(db.Conn)
+	log.Println("Initialize queries")
+	shared.Queries = queries.CreateQueries(db.Conn)
+	log.Println("Initialize carves")
+	shared.Carves = carves.CreateFileCarves(db.Conn, carver, s3)
+	log.Println("Initialize response actions")
+	shared.Responses = responses.CreateResponseManager(db.Conn, shared.Queries)
+	log.Println("Initialize dead letters")
+	shared.DeadLetters = logging.CreateDeadLetters(db.Conn)
+	log.Println("Initialize webhooks")
+	shared.Webhooks = webhooks.CreateWebhooks(db.Conn)
+	PublishWebhooks(shared)
+	return shared, nil
+}
+
+// Stop to write what is pending in the managers before shutting down, once all the services using them stopped
+func (s *Shared) Stop(timeout time.Duration) error {
+	if err := s.Envs.StopAccessLog(timeout); err != nil {
+		return fmt.Errorf("error writing data access log %v", err)
+	}
+	return nil
+}
+
+// StartPoolMonitor to check the connection pools of the backend until ctx is cancelled, publishing their statistics
+// for the stats API and passing them to send if it is provided. Services start it for the managers they create,
+// and the process sharing the managers with all services starts it once for all of them.
+func (s *Shared) StartPoolMonitor(ctx context.Context, instance, service string, send func([]backend.PoolStats)) {
+	if _, err := s.DB.CheckPoolCapacity(); err != nil {
+		log.Printf("error checking DB pool capacity %v", err)
+	}
+	s.DB.StartPoolMonitor(ctx, backend.DefaultPoolCheck, backend.DefaultPoolWaitThreshold, func(stats []backend.PoolStats) {
+		if send != nil {
+			send(stats)
+		}
+		poolStats := backend.InstancePoolStats{Instance: instance, Service: service, Pools: stats}
+		if err := s.Redis.SetPoolStats(poolStats, 3*backend.DefaultPoolCheck); err != nil {
+			log.Printf("error publishing pool stats %v", err)
+		}
+	})
+}
+
+// PublishWebhooks to queue webhook events when queries and carves complete and response actions fire.
+// Events are only stored, so producers are not slowed down by endpoints, and errors are only logged.
+func PublishWebhooks(shared *Shared) {
+	publish := func(envid uint, event string, entity webhooks.Entity, data interface{}) {
+		if _, err := shared.Webhooks.Publish(envid, event, entity, data); err != nil {
+			log.Printf("error publishing webhook event %s %v", event, err)
+		}
+	}
+	shared.Queries.OnComplete = func(q queries.DistributedQuery) {
+		publish(q.EnvironmentID, webhooks.EventQueryCompleted, webhooks.Entity{Type: webhooks.EntityQuery, ID: q.Name}, map[string]interface{}{
+			"name":       q.Name,
+			"type":       q.Type,
+			"creator":    q.Creator,
+			"query":      q.Query,
+			"path":       q.Path,
+			"expected":   q.Expected,
+			"executions": q.Executions,
+			"errors":     q.Errors,
+			"expired":    q.Expired(time.Now()),
+			"created_at": q.CreatedAt,
+		})
+	}
+	shared.Carves.OnComplete = func(c carves.CarvedFile) {
+		publish(c.EnvironmentID, webhooks.EventCarveCompleted, webhooks.Entity{Type: webhooks.EntityCarve, ID: c.CarveID}, map[string]interface{}{
+			"carve_id":     c.CarveID,
+			"session_id":   c.SessionID,
+			"query_name":   c.QueryName,
+			"node":         c.UUID,
+			"path":         c.Path,
+			"size":         c.CarveSize,
+			"blocks":       c.TotalBlocks,
+			"carver":       c.Carver,
+			"completed_at": c.CompletedAt,
+		})
+	}
+	shared.Responses.OnFire = func(a responses.ResponseAction) {
+		publish(a.EnvironmentID, webhooks.EventResponseFired, webhooks.Entity{Type: webhooks.EntityResponse, ID: fmt.Sprint(a.ID)}, map[string]interface{}{
+			"id":         a.ID,
+			"trigger":    a.Trigger,
+			"node":       a.NodeUUID,
+			"kind":       a.Kind,
+			"source":     a.Source,
+			"status":     a.Status,
+			"target":     a.Target,
+			"query_name": a.QueryName,
+			"message":    a.Message,
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package settings
 
 import (
+	"context"
 	"fmt"
 	"log"
 
@@ -67,20 +68,71 @@ const (
 
 // Names for all possible settings values for services
 const (
-	DebugHTTP          string = "debug_http"
-	DebugService       string = "debug_service"
-	RefreshEnvs        string = "refresh_envs"
-	RefreshSettings    string = "refresh_settings"
-	CleanupSessions    string = "cleanup_sessions"
-	ServiceMetrics     string = "service_metrics"
-	MetricsHost        string = "metrics_host"
-	MetricsPort        string = "metrics_port"
-	MetricsProtocol    string = "metrics_protocol"
-	DefaultEnv         string = "default_env"
-	InactiveHours      string = "inactive_hours"
-	AcceleratedSeconds string = "accelerated_seconds"
-	NodeDashboard      string = "node_dashboard"
-	OnelinerExpiration string = "oneliner_expiration"
+	DebugHTTP           string = "debug_http"
+	DebugService        string = "debug_service"
+	RefreshEnvs         string = "refresh_envs"
+	RefreshSettings     string = "refresh_settings"
+	RefreshSplay        string = "refresh_splay_percent"
+	CleanupSessions     string = "cleanup_sessions"
+	ServiceMetrics      string = "service_metrics"
+	MetricsHost         string = "metrics_host"
+	MetricsPort         string = "metrics_port"
+	MetricsProtocol     string = "metrics_protocol"
+	DefaultEnv          string = "default_env"
+	InactiveHours       string = "inactive_hours"
+	AcceleratedSeconds  string = "accelerated_seconds"
+	NodeDashboard       string = "node_dashboard"
+	OnelinerExpiration  string = "oneliner_expiration"
+	AlwaysLogTypes      string = "always_log_types"
+	AlwaysLogNames      string = "always_log_names"
+	AlwaysLogDisabled   string = "always_log_disabled_envs"
+	DecommissionGrace   string = "decommission_grace_days"
+	CarveScanner        string = "carve_scanner"
+	CarveScanTimeout    string = "carve_scan_timeout"
+	StormFactor         string = "storm_backoff_factor"
+	StormPercent        string = "storm_backoff_percent"
+	StormCooldown       string = "storm_cooldown"
+	HistoryRetention    string = "node_history_retention_days"
+	MembershipRetention string = "node_membership_retention_days"
+	LineageRetention    string = "config_lineage_retention_days"
+	AccessRetention     string = "data_access_retention_days"
+	DeadLetterEntries   string = "dead_letter_max_entries"
+	DeadLetterDays      string = "dead_letter_max_days"
+	StatusMaxMessage    string = "status_max_message_bytes"
+	LogMaxBody          string = "log_max_body_mb"
+	LogStreamEntries    string = "log_stream_entries"
+	DecompressMaxBody   string = "decompress_max_body_mb"
+	MaxBody             string = "max_body_mb"
+	MaxBodySmall        string = "max_body_small_mb"
+	MaxBodyCarve        string = "max_body_carve_mb"
+	StatusTokens        string = "status_tokens"
+	StatusTokenHours    string = "status_token_max_hours"
+	IdempotencyHours    string = "idempotency_hours"
+	PlatformPolicy      string = "enroll_platform_policy"
+	IdentityThreshold   string = "identity_similarity_threshold"
+	IdentityWebhook     string = "identity_mismatch_webhook"
+	LoginMaxFailures    string = "login_max_failures"
+	LoginLockoutMins    string = "login_lockout_minutes"
+	LoginLockoutHook    string = "login_lockout_webhook"
+	ClockSkewSeconds    string = "clock_skew_seconds"
+	TagJobBatch         string = "tag_job_batch_size"
+	TagJobConfirm       string = "tag_job_confirm_threshold"
+	AttributesMaxBytes  string = "node_attributes_max_bytes"
+	TrashRetention      string = "trash_retention_days"
+	ConfigConflicts     string = "config_conflicts"
+	QueryBudget         string = "config_query_budget"
+	StorageQuotaHook    string = "storage_quota_webhook"
+	MuteExpiryHook      string = "mute_expiry_webhook"
+	OsquerydCheckPath   string = "osqueryd_check_path"
+	QueryExpirationMin  string = "query_expiration_min_hours"
+	QueryExpirationMax  string = "query_expiration_max_hours"
+	RestrictedListing   string = "restricted_queries_listing"
+	DuplicateScore      string = "duplicate_score_threshold"
+	DuplicateHostname   string = "duplicate_hostname_distance"
+	MergeSplitDays      string = "merge_split_days"
+	QueryArchiveDays    string = "query_archive_days"
+	ArchiveExecutions   string = "query_archive_executions"
+	RehydrateHours      string = "query_rehydrate_hours"
 )
 
 // Names for the values that are read from the JSON config file
@@ -92,6 +144,7 @@ const (
 	JSONLogger     string = "json_logger"
 	JSONCarver     string = "json_carver"
 	JSONSessionKey string = "json_sessionkey"
+	JSONURLPrefix  string = "json_url_prefix"
 )
 
 // SettingValue to hold each value for settings
@@ -112,7 +165,8 @@ type MapSettings map[string]SettingValue
 
 // Settings keeps all settings values
 type Settings struct {
-	DB *gorm.DB
+	DB     *gorm.DB
+	Reader func() *gorm.DB
 }
 
 // ValidTypes to check validity of settings type
@@ -140,6 +194,14 @@ func NewSettings(backend *gorm.DB) *Settings {
 	return s
 }
 
+// Helper to get the connection for reads that tolerate replication lag, DB if there is no Reader
+func (conf *Settings) read() *gorm.DB {
+	if conf.Reader != nil {
+		return conf.Reader()
+	}
+	return conf.DB
+}
+
 // EmptyValue creates an empty value
 func (conf *Settings) EmptyValue(service, name, typeValue string) SettingValue {
 	return SettingValue{
@@ -228,7 +290,7 @@ func (conf *Settings) DeleteValue(service, name string) error {
 // RetrieveAllValues retrieves and returns all values excepting JSON from backend
 func (conf *Settings) RetrieveAllValues() ([]SettingValue, error) {
 	var values []SettingValue
-	if err := conf.DB.Where("json = ?", false).Find(&values).Error; err != nil {
+	if err := conf.read().Where("json = ?", false).Find(&values).Error; err != nil {
 		return values, err
 	}
 	return values, nil
@@ -237,7 +299,7 @@ func (conf *Settings) RetrieveAllValues() ([]SettingValue, error) {
 // RetrieveAll retrieves and returns all values from backend
 func (conf *Settings) RetrieveAll() ([]SettingValue, error) {
 	var values []SettingValue
-	if err := conf.DB.Find(&values).Error; err != nil {
+	if err := conf.read().Find(&values).Error; err != nil {
 		return values, err
 	}
 	return values, nil
@@ -246,7 +308,7 @@ func (conf *Settings) RetrieveAll() ([]SettingValue, error) {
 // RetrieveAllJSON retrieves and returns all JSON values from backend
 func (conf *Settings) RetrieveAllJSON(service string) ([]SettingValue, error) {
 	var values []SettingValue
-	if err := conf.DB.Where("service = ? AND json = ?", service, true).Find(&values).Error; err != nil {
+	if err := conf.read().Where("service = ? AND json = ?", service, true).Find(&values).Error; err != nil {
 		return values, err
 	}
 	return values, nil
@@ -309,6 +371,9 @@ func (conf *Settings) SetAdminJSON(cfg types.JSONConfigurationAdmin) error {
 	if err := conf.SetJSON(ServiceAdmin, JSONSessionKey, cfg.SessionKey); err != nil {
 		return err
 	}
+	if err := conf.SetJSON(ServiceAdmin, JSONURLPrefix, cfg.URLPrefix); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -326,13 +391,21 @@ func (conf *Settings) SetAPIJSON(cfg types.JSONConfigurationAPI) error {
 	if err := conf.SetJSON(ServiceAPI, JSONAuth, cfg.Auth); err != nil {
 		return err
 	}
+	if err := conf.SetJSON(ServiceAPI, JSONURLPrefix, cfg.URLPrefix); err != nil {
+		return err
+	}
 	return nil
 }
 
 // RetrieveValues retrieves and returns all values from backend
 func (conf *Settings) RetrieveValues(service string, jsonSetting bool) ([]SettingValue, error) {
+	return conf.RetrieveValuesCtx(context.Background(), service, jsonSetting)
+}
+
+// RetrieveValuesCtx retrieves and returns all values from backend, aborting if ctx is cancelled
+func (conf *Settings) RetrieveValuesCtx(ctx context.Context, service string, jsonSetting bool) ([]SettingValue, error) {
 	var values []SettingValue
-	if err := conf.DB.Where("service = ? AND json = ?", service, jsonSetting).Find(&values).Error; err != nil {
+	if err := conf.read().WithContext(ctx).Where("service = ? AND json = ?", service, jsonSetting).Find(&values).Error; err != nil {
 		return values, err
 	}
 	return values, nil
@@ -347,6 +420,15 @@ func (conf *Settings) RetrieveValue(service, name string) (SettingValue, error)
 	return value, nil
 }
 
+// Helper to retrieve a value that tolerates replication lag
+func (conf *Settings) retrieveReadValue(service, name string) (SettingValue, error) {
+	var value SettingValue
+	if err := conf.read().Where("json = ? AND service = ?", false, service).Where("name = ?", name).First(&value).Error; err != nil {
+		return value, err
+	}
+	return value, nil
+}
+
 // RetrieveJSON retrieves one JSON value from settings by service and name from backend
 func (conf *Settings) RetrieveJSON(service, name string) (SettingValue, error) {
 	var value SettingValue
@@ -358,7 +440,12 @@ func (conf *Settings) RetrieveJSON(service, name string) (SettingValue, error) {
 
 // GetMap returns the map of values by service, excluding JSON
 func (conf *Settings) GetMap(service string) (MapSettings, error) {
-	all, err := conf.RetrieveValues(service, false)
+	return conf.GetMapCtx(context.Background(), service)
+}
+
+// GetMapCtx returns the map of values by service, aborting if ctx is cancelled
+func (conf *Settings) GetMapCtx(ctx context.Context, service string) (MapSettings, error) {
+	all, err := conf.RetrieveValuesCtx(ctx, service, false)
 	if err != nil {
 		return MapSettings{}, fmt.Errorf("error getting values %v", err)
 	}
@@ -499,7 +586,7 @@ func (conf *Settings) IsJSON(service, name string) bool {
 
 // DebugHTTP checks if http debugging is enabled by service
 func (conf *Settings) DebugHTTP(service string) bool {
-	value, err := conf.RetrieveValue(service, DebugHTTP)
+	value, err := conf.retrieveReadValue(service, DebugHTTP)
 	if err != nil {
 		return false
 	}
@@ -508,7 +595,7 @@ func (conf *Settings) DebugHTTP(service string) bool {
 
 // DebugService checks if debugging is enabled by service
 func (conf *Settings) DebugService(service string) bool {
-	value, err := conf.RetrieveValue(service, DebugService)
+	value, err := conf.retrieveReadValue(service, DebugService)
 	if err != nil {
 		return false
 	}
@@ -517,7 +604,7 @@ func (conf *Settings) DebugService(service string) bool {
 
 // ServiceMetrics checks if metrics are enabled by service
 func (conf *Settings) ServiceMetrics(service string) bool {
-	value, err := conf.RetrieveValue(service, ServiceMetrics)
+	value, err := conf.retrieveReadValue(service, ServiceMetrics)
 	if err != nil {
 		return false
 	}
@@ -526,7 +613,7 @@ func (conf *Settings) ServiceMetrics(service string) bool {
 
 // RefreshEnvs gets the interval in seconds to refresh environments by service
 func (conf *Settings) RefreshEnvs(service string) int64 {
-	value, err := conf.RetrieveValue(service, RefreshEnvs)
+	value, err := conf.retrieveReadValue(service, RefreshEnvs)
 	if err != nil {
 		return 0
 	}
@@ -535,7 +622,16 @@ func (conf *Settings) RefreshEnvs(service string) int64 {
 
 // RefreshSettings gets the interval in seconds to refresh settings by service
 func (conf *Settings) RefreshSettings(service string) int64 {
-	value, err := conf.RetrieveValue(service, RefreshSettings)
+	value, err := conf.retrieveReadValue(service, RefreshSettings)
+	if err != nil {
+		return 0
+	}
+	return value.Integer
+}
+
+// RefreshSplay gets the percentage of the refresh intervals to splay refreshes by service, 0 if not set
+func (conf *Settings) RefreshSplay(service string) int64 {
+	value, err := conf.retrieveReadValue(service, RefreshSplay)
 	if err != nil {
 		return 0
 	}
@@ -544,7 +640,7 @@ func (conf *Settings) RefreshSettings(service string) int64 {
 
 // CleanupSessions gets the interval in seconds to cleanup expired sessions by service
 func (conf *Settings) CleanupSessions() int64 {
-	value, err := conf.RetrieveValue(ServiceAdmin, CleanupSessions)
+	value, err := conf.retrieveReadValue(ServiceAdmin, CleanupSessions)
 	if err != nil {
 		return 0
 	}
@@ -553,7 +649,97 @@ func (conf *Settings) CleanupSessions() int64 {
 
 // InactiveHours gets the value in hours for a node to be inactive by service
 func (conf *Settings) InactiveHours() int64 {
-	value, err := conf.RetrieveValue(ServiceAdmin, InactiveHours)
+	value, err := conf.retrieveReadValue(ServiceAdmin, InactiveHours)
+	if err != nil {
+		return 0
+	}
+	return value.Integer
+}
+
+// ClockSkewSeconds gets the seconds of clock skew to flag nodes, 0 if not set
+func (conf *Settings) ClockSkewSeconds() int64 {
+	value, err := conf.retrieveReadValue(ServiceAdmin, ClockSkewSeconds)
+	if err != nil {
+		return 0
+	}
+	return value.Integer
+}
+
+// TagJobBatchSize gets the number of nodes tagged in each batch of tag jobs, 0 if not set
+func (conf *Settings) TagJobBatchSize() int {
+	value, err := conf.retrieveReadValue(ServiceAdmin, TagJobBatch)
+	if err != nil {
+		return 0
+	}
+	return int(value.Integer)
+}
+
+// TagJobConfirmThreshold gets the number of nodes above which tag operations need confirmation, 0 if not set
+func (conf *Settings) TagJobConfirmThreshold() int {
+	value, err := conf.retrieveReadValue(ServiceAdmin, TagJobConfirm)
+	if err != nil {
+		return 0
+	}
+	return int(value.Integer)
+}
+
+// AttributesMaxBytes gets the maximum size of the custom attributes of one node, 0 if not set
+func (conf *Settings) AttributesMaxBytes() int {
+	value, err := conf.retrieveReadValue(ServiceAdmin, AttributesMaxBytes)
+	if err != nil {
+		return 0
+	}
+	return int(value.Integer)
+}
+
+// TrashRetentionDays gets the days that deleted items can be restored before they are purged, 0 if not set
+func (conf *Settings) TrashRetentionDays() int {
+	value, err := conf.retrieveReadValue(ServiceAdmin, TrashRetention)
+	if err != nil {
+		return 0
+	}
+	return int(value.Integer)
+}
+
+// ConfigConflicts gets how conflicts in configurations are handled when they are saved: off, warn or error
+func (conf *Settings) ConfigConflicts() string {
+	value, err := conf.retrieveReadValue(ServiceAdmin, ConfigConflicts)
+	if err != nil {
+		return ""
+	}
+	return value.String
+}
+
+// RestrictedListing gets how queries with restricted visibility are listed to who can not see them: hide or lock
+func (conf *Settings) RestrictedListing() string {
+	value, err := conf.retrieveReadValue(ServiceAdmin, RestrictedListing)
+	if err != nil {
+		return ""
+	}
+	return value.String
+}
+
+// QueryBudget gets the number of scheduled queries that can run together in one node, 0 if not set
+func (conf *Settings) QueryBudget() int {
+	value, err := conf.retrieveReadValue(ServiceAdmin, QueryBudget)
+	if err != nil {
+		return 0
+	}
+	return int(value.Integer)
+}
+
+// QueryExpirationMinHours gets the shortest expiration in hours that can be requested for queries, 0 if not set
+func (conf *Settings) QueryExpirationMinHours() int64 {
+	value, err := conf.retrieveReadValue(ServiceAdmin, QueryExpirationMin)
+	if err != nil {
+		return 0
+	}
+	return value.Integer
+}
+
+// QueryExpirationMaxHours gets the longest expiration in hours that can be requested for queries, 0 if not set
+func (conf *Settings) QueryExpirationMaxHours() int64 {
+	value, err := conf.retrieveReadValue(ServiceAdmin, QueryExpirationMax)
 	if err != nil {
 		return 0
 	}
@@ -563,7 +749,7 @@ func (conf *Settings) InactiveHours() int64 {
 // DefaultEnv gets the default environment
 // FIXME customize the fallover one
 func (conf *Settings) DefaultEnv(service string) string {
-	value, err := conf.RetrieveValue(service, DefaultEnv)
+	value, err := conf.retrieveReadValue(service, DefaultEnv)
 	if err != nil {
 		return "dev"
 	}
@@ -572,16 +758,223 @@ func (conf *Settings) DefaultEnv(service string) string {
 
 // NodeDashboard checks if display dashboard per node is enabled
 func (conf *Settings) NodeDashboard() bool {
-	value, err := conf.RetrieveValue(ServiceAdmin, NodeDashboard)
+	value, err := conf.retrieveReadValue(ServiceAdmin, NodeDashboard)
 	if err != nil {
 		return false
 	}
 	return value.Boolean
 }
 
+// DecommissionGraceDays gets the days decommissioned nodes have to stop contacting
+func (conf *Settings) DecommissionGraceDays() int64 {
+	value, err := conf.retrieveReadValue(ServiceAdmin, DecommissionGrace)
+	if err != nil {
+		return 0
+	}
+	return value.Integer
+}
+
+// HistoryRetentionDays gets the days to keep node count history, 0 keeps it forever
+func (conf *Settings) HistoryRetentionDays() int {
+	value, err := conf.retrieveReadValue(ServiceAdmin, HistoryRetention)
+	if err != nil {
+		return 0
+	}
+	return int(value.Integer)
+}
+
+// MembershipRetentionDays gets the days to keep the history of environments and tags of nodes, 0 keeps it forever
+func (conf *Settings) MembershipRetentionDays() int {
+	value, err := conf.retrieveReadValue(ServiceAdmin, MembershipRetention)
+	if err != nil {
+		return 0
+	}
+	return int(value.Integer)
+}
+
+// LineageRetentionDays gets the days to keep the configuration lineage of nodes, 0 keeps it forever
+func (conf *Settings) LineageRetentionDays() int {
+	value, err := conf.retrieveReadValue(ServiceAdmin, LineageRetention)
+	if err != nil {
+		return 0
+	}
+	return int(value.Integer)
+}
+
+// AccessRetentionDays gets the days to keep the data access log, 0 keeps it forever
+func (conf *Settings) AccessRetentionDays() int {
+	value, err := conf.retrieveReadValue(ServiceAdmin, AccessRetention)
+	if err != nil {
+		return 0
+	}
+	return int(value.Integer)
+}
+
+// DuplicateScore gets the score, in percent, to propose two nodes as duplicates of the same host
+func (conf *Settings) DuplicateScore() int {
+	value, err := conf.retrieveReadValue(ServiceAdmin, DuplicateScore)
+	if err != nil {
+		return 0
+	}
+	return int(value.Integer)
+}
+
+// DuplicateHostnameDistance gets the number of edits for hostnames of duplicate nodes to be near-identical
+func (conf *Settings) DuplicateHostnameDistance() int {
+	value, err := conf.retrieveReadValue(ServiceAdmin, DuplicateHostname)
+	if err != nil {
+		return -1
+	}
+	return int(value.Integer)
+}
+
+// MergeSplitDays gets the days a merge of duplicate nodes can be split back
+func (conf *Settings) MergeSplitDays() int {
+	value, err := conf.retrieveReadValue(ServiceAdmin, MergeSplitDays)
+	if err != nil {
+		return 0
+	}
+	return int(value.Integer)
+}
+
+// QueryArchiveDays gets the days after which completed and expired queries are archived, 0 does not archive them
+func (conf *Settings) QueryArchiveDays() int {
+	value, err := conf.retrieveReadValue(ServiceAdmin, QueryArchiveDays)
+	if err != nil {
+		return 0
+	}
+	return int(value.Integer)
+}
+
+// ArchiveExecutions checks if the executions of each node are archived with the results of queries
+func (conf *Settings) ArchiveExecutions() bool {
+	value, err := conf.retrieveReadValue(ServiceAdmin, ArchiveExecutions)
+	if err != nil {
+		return false
+	}
+	return value.Boolean
+}
+
+// RehydrateHours gets the hours the results of archived queries are kept after they are rehydrated
+func (conf *Settings) RehydrateHours() int {
+	value, err := conf.retrieveReadValue(ServiceAdmin, RehydrateHours)
+	if err != nil {
+		return 0
+	}
+	return int(value.Integer)
+}
+
+// StatusTokens checks if node status tokens for help desk verification are enabled
+func (conf *Settings) StatusTokens() bool {
+	value, err := conf.retrieveReadValue(ServiceAdmin, StatusTokens)
+	if err != nil {
+		return false
+	}
+	return value.Boolean
+}
+
+// StatusTokenMaxHours gets the maximum lifetime in hours of node status tokens
+func (conf *Settings) StatusTokenMaxHours() int64 {
+	value, err := conf.retrieveReadValue(ServiceAdmin, StatusTokenHours)
+	if err != nil {
+		return 0
+	}
+	return value.Integer
+}
+
+// LoginMaxFailures gets the failed logins of a username or token before it is locked out
+func (conf *Settings) LoginMaxFailures() int64 {
+	value, err := conf.retrieveReadValue(ServiceAdmin, LoginMaxFailures)
+	if err != nil {
+		return 0
+	}
+	return value.Integer
+}
+
+// LoginLockoutMinutes gets the duration in minutes of login lockouts
+func (conf *Settings) LoginLockoutMinutes() int64 {
+	value, err := conf.retrieveReadValue(ServiceAdmin, LoginLockoutMins)
+	if err != nil {
+		return 0
+	}
+	return value.Integer
+}
+
+// LoginLockoutWebhook gets the webhook URL to notify login lockouts
+func (conf *Settings) LoginLockoutWebhook() string {
+	value, err := conf.retrieveReadValue(ServiceAdmin, LoginLockoutHook)
+	if err != nil {
+		return ""
+	}
+	return value.String
+}
+
+// StorageQuotaWebhook gets the webhook URL to notify storage quotas exceeded or lifted
+func (conf *Settings) StorageQuotaWebhook() string {
+	value, err := conf.retrieveReadValue(ServiceAdmin, StorageQuotaHook)
+	if err != nil {
+		return ""
+	}
+	return value.String
+}
+
+// MuteExpiryWebhook gets the webhook URL to notify expired mutes to who set them
+func (conf *Settings) MuteExpiryWebhook() string {
+	value, err := conf.retrieveReadValue(ServiceAdmin, MuteExpiryHook)
+	if err != nil {
+		return ""
+	}
+	return value.String
+}
+
+// OsquerydCheckPath gets the path of a local osqueryd to check flags and configuration, empty when disabled
+func (conf *Settings) OsquerydCheckPath() string {
+	value, err := conf.retrieveReadValue(ServiceAdmin, OsquerydCheckPath)
+	if err != nil {
+		return ""
+	}
+	return value.String
+}
+
+// IdempotencyHours gets the hours to keep the responses of requests with idempotency keys
+func (conf *Settings) IdempotencyHours() int64 {
+	value, err := conf.retrieveReadValue(ServiceAPI, IdempotencyHours)
+	if err != nil {
+		return 0
+	}
+	return value.Integer
+}
+
+// CarveScanner gets the webhook URL or local command to scan completed carves
+func (conf *Settings) CarveScanner() string {
+	value, err := conf.retrieveReadValue(ServiceTLS, CarveScanner)
+	if err != nil {
+		return ""
+	}
+	return value.String
+}
+
+// CarveScanTimeout gets the seconds the carve scanner has to return a verdict
+func (conf *Settings) CarveScanTimeout() int64 {
+	value, err := conf.retrieveReadValue(ServiceTLS, CarveScanTimeout)
+	if err != nil {
+		return 0
+	}
+	return value.Integer
+}
+
+// PlatformPolicy gets the policy for enrolls of nodes with platforms not allowed in the environment
+func (conf *Settings) PlatformPolicy() string {
+	value, err := conf.retrieveReadValue(ServiceTLS, PlatformPolicy)
+	if err != nil {
+		return ""
+	}
+	return value.String
+}
+
 // OnelinerExpiration checks if enrolling links will expire
 func (conf *Settings) OnelinerExpiration() bool {
-	value, err := conf.RetrieveValue(ServiceTLS, OnelinerExpiration)
+	value, err := conf.retrieveReadValue(ServiceTLS, OnelinerExpiration)
 	if err != nil {
 		return false
 	}
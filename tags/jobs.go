@@ -0,0 +1,581 @@
+package tags
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jmpsec/osctrl/nodes"
+	"gorm.io/gorm"
+)
+
+const (
+	// TagJobPending for jobs waiting to be picked up
+	TagJobPending string = "pending"
+	// TagJobRunning for jobs being processed by one instance
+	TagJobRunning string = "running"
+	// TagJobCompleted for jobs with all nodes processed
+	TagJobCompleted string = "completed"
+	// TagJobFailed for jobs that stopped because of an error
+	TagJobFailed string = "failed"
+	// TagActionAdd to add a tag to a node
+	TagActionAdd string = "add"
+	// TagActionRemove to remove a tag from a node
+	TagActionRemove string = "remove"
+)
+
+const (
+	// DefaultTagJobBatch is the default number of nodes tagged in each batch
+	DefaultTagJobBatch = 100
+	// DefaultTagJobConfirm is the default number of nodes above which tag operations need confirmation
+	DefaultTagJobConfirm = 500
+	// DefaultTagJobInterval is the default interval to look for tag jobs to process
+	DefaultTagJobInterval = 5 * time.Second
+	// DefaultTagJobLease is the default time an instance keeps a job without finishing a batch
+	DefaultTagJobLease = 2 * time.Minute
+)
+
+// ErrTagJobLost when the lease of a job was taken by another instance
+var ErrTagJobLost = errors.New("tag job lease lost")
+
+// ErrTagJobConfirm when a tag operation affects more nodes than the threshold without confirmation
+type ErrTagJobConfirm struct {
+	Total     int
+	Threshold int
+}
+
+func (e *ErrTagJobConfirm) Error() string {
+	return fmt.Sprintf("tagging %d nodes is above the threshold of %d and needs confirmation", e.Total, e.Threshold)
+}
+
+// TagJob to tag many nodes in batches, with progress and counts that survive restarts
+type TagJob struct {
+	gorm.Model
+	CreatedBy      string    `json:"created_by"`
+	Status         string    `gorm:"index" json:"status"`
+	TagsAdd        string    `json:"tags_add"`
+	TagsRemove     string    `json:"tags_remove"`
+	Total          int       `json:"total"`
+	Processed      int       `json:"processed"`
+	Added          int       `json:"added"`
+	Removed        int       `json:"removed"`
+	AlreadyPresent int       `json:"already_present"`
+	NotPresent     int       `json:"not_present"`
+	Conflicts      int       `json:"conflicts"`
+	Owner          string    `json:"-"`
+	LeaseUntil     time.Time `json:"-"`
+	Error          string    `json:"error"`
+	CompletedAt    time.Time `json:"completed_at"`
+}
+
+// TagJobNode to keep the nodes of a job and which ones are processed
+type TagJobNode struct {
+	ID     uint `gorm:"primaryKey"`
+	JobID  uint `gorm:"index"`
+	NodeID uint `gorm:"index"`
+	Done   bool
+}
+
+// TagJobConflict to record when two unfinished jobs change the same tag of a node in opposite ways,
+// the job that processed the node last wins
+type TagJobConflict struct {
+	gorm.Model
+	JobID      uint `gorm:"index"`
+	OtherJobID uint
+	NodeID     uint
+	Tag        string
+	Action     string
+}
+
+// TagBatch with the changes of one batch of a job, applied at once so counts stay exact
+type TagBatch struct {
+	NodeIDs        []uint
+	Add            map[uint][]string
+	Remove         map[uint][]string
+	Added          int
+	Removed        int
+	AlreadyPresent int
+	NotPresent     int
+	Conflicts      []TagJobConflict
+}
+
+// TagJobStore to persist jobs, implemented by TagManager
+type TagJobStore interface {
+	ClaimJob(owner string, now time.Time, lease time.Duration) (TagJob, bool, error)
+	PendingNodes(jobID uint, limit int) ([]uint, error)
+	TaggedNodes(nodeIDs []uint, tags []string) (map[uint]map[string]bool, error)
+	OverlappingJobs(job TagJob, nodeIDs []uint) (map[uint]TagJob, map[uint][]uint, error)
+	ApplyBatch(job TagJob, owner string, batch TagBatch, leaseUntil time.Time) error
+	FinishJob(job TagJob, owner, status, errMsg string, now time.Time) error
+}
+
+// SplitTags to get the tags of a job from its comma separated values
+func SplitTags(value string) []string {
+	var res []string
+	for _, t := range strings.Split(value, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			res = append(res, t)
+		}
+	}
+	return res
+}
+
+// Progress of a job in percentage
+func (j TagJob) Progress() int {
+	if j.Total == 0 {
+		return 100
+	}
+	return j.Processed * 100 / j.Total
+}
+
+// Finished to check if a job is not going to process more nodes
+func (j TagJob) Finished() bool {
+	return j.Status == TagJobCompleted || j.Status == TagJobFailed
+}
+
+// NewTagJob to prepare a job for the given number of nodes, tag operations above the threshold need
+// to be confirmed and a threshold of zero disables confirmations
+func NewTagJob(user string, add, remove []string, total, threshold int, confirmed bool) (TagJob, error) {
+	if len(add) == 0 && len(remove) == 0 {
+		return TagJob{}, fmt.Errorf("no tags to add or remove")
+	}
+	if total == 0 {
+		return TagJob{}, fmt.Errorf("no nodes to tag")
+	}
+	if threshold > 0 && total > threshold && !confirmed {
+		return TagJob{}, &ErrTagJobConfirm{Total: total, Threshold: threshold}
+	}
+	return TagJob{
+		CreatedBy:  user,
+		Status:     TagJobPending,
+		TagsAdd:    strings.Join(add, ","),
+		TagsRemove: strings.Join(remove, ","),
+		Total:      total,
+	}, nil
+}
+
+// Helper to check if a tag is in a list
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// PlanBatch to calculate the changes of a batch from the current tags of the nodes, and the conflicts
+// with the overlapping jobs that change the same tags of the same nodes in the opposite way
+func PlanBatch(job TagJob, nodeIDs []uint, tagged map[uint]map[string]bool, others map[uint]TagJob, othersNodes map[uint][]uint) TagBatch {
+	batch := TagBatch{
+		NodeIDs: nodeIDs,
+		Add:     make(map[uint][]string),
+		Remove:  make(map[uint][]string),
+	}
+	add := SplitTags(job.TagsAdd)
+	remove := SplitTags(job.TagsRemove)
+	inOther := make(map[uint]map[uint]bool)
+	for otherID, ids := range othersNodes {
+		inOther[otherID] = make(map[uint]bool)
+		for _, id := range ids {
+			inOther[otherID][id] = true
+		}
+	}
+	otherIDs := make([]uint, 0, len(others))
+	for otherID := range others {
+		otherIDs = append(otherIDs, otherID)
+	}
+	sort.Slice(otherIDs, func(i, j int) bool { return otherIDs[i] < otherIDs[j] })
+	conflict := func(nodeID uint, tag, action string) {
+		for _, otherID := range otherIDs {
+			if !inOther[otherID][nodeID] {
+				continue
+			}
+			other := others[otherID]
+			opposite := SplitTags(other.TagsRemove)
+			if action == TagActionRemove {
+				opposite = SplitTags(other.TagsAdd)
+			}
+			if hasTag(opposite, tag) {
+				batch.Conflicts = append(batch.Conflicts, TagJobConflict{
+					JobID:      job.ID,
+					OtherJobID: otherID,
+					NodeID:     nodeID,
+					Tag:        tag,
+					Action:     action,
+				})
+			}
+		}
+	}
+	for _, id := range nodeIDs {
+		for _, t := range remove {
+			conflict(id, t, TagActionRemove)
+			if tagged[id][t] {
+				batch.Remove[id] = append(batch.Remove[id], t)
+				batch.Removed++
+			} else {
+				batch.NotPresent++
+			}
+		}
+		for _, t := range add {
+			conflict(id, t, TagActionAdd)
+			if tagged[id][t] && !hasTag(batch.Remove[id], t) {
+				batch.AlreadyPresent++
+			} else {
+				batch.Add[id] = append(batch.Add[id], t)
+				batch.Added++
+			}
+		}
+	}
+	return batch
+}
+
+// TagJobRunner to process tag jobs in batches, one instance at a time for each job
+type TagJobRunner struct {
+	Store     TagJobStore
+	Owner     string
+	Lease     time.Duration
+	BatchSize func() int
+	job       *TagJob
+}
+
+// NewTagJobRunner to initialize a runner, the owner identifies this instance
+func NewTagJobRunner(store TagJobStore, batchSize func() int) *TagJobRunner {
+	hostname, _ := os.Hostname()
+	return &TagJobRunner{
+		Store:     store,
+		Owner:     fmt.Sprintf("%s-%d-%d", hostname, os.Getpid(), time.Now().UnixNano()),
+		Lease:     DefaultTagJobLease,
+		BatchSize: batchSize,
+	}
+}
+
+// Step to process one batch of the current job, claiming a job if there is none. Returns false when
+// there is nothing to process. Jobs held by instances that stopped are claimed when their lease expires.
+func (r *TagJobRunner) Step(now time.Time) (bool, error) {
+	if r.job == nil {
+		job, ok, err := r.Store.ClaimJob(r.Owner, now, r.Lease)
+		if err != nil || !ok {
+			return false, err
+		}
+		r.job = &job
+	}
+	job := *r.job
+	size := DefaultTagJobBatch
+	if r.BatchSize != nil && r.BatchSize() > 0 {
+		size = r.BatchSize()
+	}
+	nodeIDs, err := r.Store.PendingNodes(job.ID, size)
+	if err != nil {
+		return true, r.fail(job, err, now)
+	}
+	if len(nodeIDs) == 0 {
+		r.job = nil
+		return true, r.Store.FinishJob(job, r.Owner, TagJobCompleted, "", now)
+	}
+	tagged, err := r.Store.TaggedNodes(nodeIDs, append(SplitTags(job.TagsAdd), SplitTags(job.TagsRemove)...))
+	if err != nil {
+		return true, r.fail(job, err, now)
+	}
+	others, othersNodes, err := r.Store.OverlappingJobs(job, nodeIDs)
+	if err != nil {
+		return true, r.fail(job, err, now)
+	}
+	batch := PlanBatch(job, nodeIDs, tagged, others, othersNodes)
+	if err := r.Store.ApplyBatch(job, r.Owner, batch, now.Add(r.Lease)); err != nil {
+		if errors.Is(err, ErrTagJobLost) {
+			r.job = nil
+			return true, err
+		}
+		return true, r.fail(job, err, now)
+	}
+	return true, nil
+}
+
+// Helper to stop the current job with an error
+func (r *TagJobRunner) fail(job TagJob, err error, now time.Time) error {
+	r.job = nil
+	if ferr := r.Store.FinishJob(job, r.Owner, TagJobFailed, err.Error(), now); ferr != nil {
+		return fmt.Errorf("%v - %v", err, ferr)
+	}
+	return err
+}
+
+// Start to process tag jobs periodically, until the context is done
+func (r *TagJobRunner) Start(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultTagJobInterval
+	}
+	run := func() {
+		for {
+			more, err := r.Step(time.Now())
+			if err != nil {
+				log.Printf("error processing tag job %v", err)
+			}
+			if !more || ctx.Err() != nil {
+				return
+			}
+		}
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				run()
+			}
+		}
+	}()
+}
+
+// CreateJob to create a job with the nodes to tag, the tags to add are created if they do not exist
+func (m *TagManager) CreateJob(job *TagJob, nodeIDs []uint) error {
+	for _, t := range SplitTags(job.TagsAdd) {
+		if m.Exists(t) {
+			continue
+		}
+		if err := m.NewTag(t, DefaultAutocreated, "", "", job.CreatedBy); err != nil {
+			return fmt.Errorf("error creating tag %v", err)
+		}
+	}
+	for _, t := range SplitTags(job.TagsRemove) {
+		if !m.Exists(t) {
+			return fmt.Errorf("tag %s does not exist", t)
+		}
+	}
+	return m.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(job).Error; err != nil {
+			return fmt.Errorf("Create TagJob %v", err)
+		}
+		jobNodes := make([]TagJobNode, 0, len(nodeIDs))
+		for _, id := range nodeIDs {
+			jobNodes = append(jobNodes, TagJobNode{JobID: job.ID, NodeID: id})
+		}
+		if err := tx.CreateInBatches(&jobNodes, DefaultTagJobBatch).Error; err != nil {
+			return fmt.Errorf("Create TagJobNode %v", err)
+		}
+		return nil
+	})
+}
+
+// GetJob to retrieve a job by ID
+func (m *TagManager) GetJob(id uint) (TagJob, error) {
+	var job TagJob
+	if err := m.DB.First(&job, id).Error; err != nil {
+		return job, err
+	}
+	return job, nil
+}
+
+// GetJobs to retrieve the latest jobs
+func (m *TagManager) GetJobs(limit int) ([]TagJob, error) {
+	var jobs []TagJob
+	if err := m.DB.Order("id desc").Limit(limit).Find(&jobs).Error; err != nil {
+		return jobs, err
+	}
+	return jobs, nil
+}
+
+// GetJobConflicts to retrieve the conflicts recorded by a job
+func (m *TagManager) GetJobConflicts(id uint) ([]TagJobConflict, error) {
+	var conflicts []TagJobConflict
+	if err := m.DB.Where("job_id = ?", id).Find(&conflicts).Error; err != nil {
+		return conflicts, err
+	}
+	return conflicts, nil
+}
+
+// ClaimJob to take the oldest job that is pending, or running with an expired lease
+func (m *TagManager) ClaimJob(owner string, now time.Time, lease time.Duration) (TagJob, bool, error) {
+	var candidates []TagJob
+	claimable := "status = ? OR (status = ? AND (lease_until < ? OR owner = ?))"
+	if err := m.DB.Where(claimable, TagJobPending, TagJobRunning, now, owner).Order("id").Limit(5).Find(&candidates).Error; err != nil {
+		return TagJob{}, false, err
+	}
+	for _, job := range candidates {
+		// The job only changes hands if nobody claimed it in the meantime
+		res := m.DB.Model(&TagJob{}).Where("id = ?", job.ID).Where(claimable, TagJobPending, TagJobRunning, now, owner).Updates(map[string]interface{}{
+			"status":      TagJobRunning,
+			"owner":       owner,
+			"lease_until": now.Add(lease),
+		})
+		if res.Error != nil {
+			return TagJob{}, false, res.Error
+		}
+		if res.RowsAffected == 1 {
+			job.Status = TagJobRunning
+			job.Owner = owner
+			job.LeaseUntil = now.Add(lease)
+			return job, true, nil
+		}
+	}
+	return TagJob{}, false, nil
+}
+
+// PendingNodes to get the IDs of the nodes of a job not processed yet
+func (m *TagManager) PendingNodes(jobID uint, limit int) ([]uint, error) {
+	var ids []uint
+	if err := m.DB.Model(&TagJobNode{}).Where("job_id = ? AND done = ?", jobID, false).Order("id").Limit(limit).Pluck("node_id", &ids).Error; err != nil {
+		return ids, err
+	}
+	return ids, nil
+}
+
+// TaggedNodes to get which of the tags each node has
+func (m *TagManager) TaggedNodes(nodeIDs []uint, tags []string) (map[uint]map[string]bool, error) {
+	res := make(map[uint]map[string]bool)
+	var tagged []TaggedNode
+	if err := m.DB.Where("node_id IN ? AND tag IN ?", nodeIDs, tags).Find(&tagged).Error; err != nil {
+		return res, err
+	}
+	for _, t := range tagged {
+		if res[t.NodeID] == nil {
+			res[t.NodeID] = make(map[string]bool)
+		}
+		res[t.NodeID][t.Tag] = true
+	}
+	return res, nil
+}
+
+// OverlappingJobs to get the other unfinished jobs and which of the nodes they also tag
+func (m *TagManager) OverlappingJobs(job TagJob, nodeIDs []uint) (map[uint]TagJob, map[uint][]uint, error) {
+	others := make(map[uint]TagJob)
+	othersNodes := make(map[uint][]uint)
+	var jobs []TagJob
+	if err := m.DB.Where("id <> ? AND status IN ?", job.ID, []string{TagJobPending, TagJobRunning}).Find(&jobs).Error; err != nil {
+		return others, othersNodes, err
+	}
+	for _, j := range jobs {
+		var ids []uint
+		if err := m.DB.Model(&TagJobNode{}).Where("job_id = ? AND node_id IN ?", j.ID, nodeIDs).Pluck("node_id", &ids).Error; err != nil {
+			return others, othersNodes, err
+		}
+		if len(ids) > 0 {
+			others[j.ID] = j
+			othersNodes[j.ID] = ids
+		}
+	}
+	return others, othersNodes, nil
+}
+
+// ApplyBatch to write the changes of a batch, mark its nodes as done and update the counts of the job in
+// one transaction, as long as the owner still holds the job
+func (m *TagManager) ApplyBatch(job TagJob, owner string, batch TagBatch, leaseUntil time.Time) error {
+	return m.DB.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&TagJob{}).Where("id = ? AND owner = ? AND status = ?", job.ID, owner, TagJobRunning).Updates(map[string]interface{}{
+			"processed":       gorm.Expr("processed + ?", len(batch.NodeIDs)),
+			"added":           gorm.Expr("added + ?", batch.Added),
+			"removed":         gorm.Expr("removed + ?", batch.Removed),
+			"already_present": gorm.Expr("already_present + ?", batch.AlreadyPresent),
+			"not_present":     gorm.Expr("not_present + ?", batch.NotPresent),
+			"conflicts":       gorm.Expr("conflicts + ?", len(batch.Conflicts)),
+			"lease_until":     leaseUntil,
+		})
+		if res.Error != nil {
+			return fmt.Errorf("Updates %v", res.Error)
+		}
+		if res.RowsAffected == 0 {
+			return ErrTagJobLost
+		}
+		batchNodes, err := nodesByID(tx, batch.NodeIDs)
+		if err != nil {
+			return err
+		}
+		var changes []nodes.NodeMembership
+		for nodeID, names := range batch.Remove {
+			var present []string
+			if err := tx.Model(&TaggedNode{}).Where("node_id = ? AND tag IN ?", nodeID, names).Pluck("tag", &present).Error; err != nil {
+				return fmt.Errorf("Pluck %v", err)
+			}
+			if err := tx.Unscoped().Where("node_id = ? AND tag IN ?", nodeID, names).Delete(&TaggedNode{}).Error; err != nil {
+				return fmt.Errorf("Delete %v", err)
+			}
+			for _, name := range present {
+				changes = append(changes, tagMembership(batchNodes[nodeID], name, nodes.MembershipRemoved, job.CreatedBy))
+			}
+		}
+		ids := make(map[string]uint)
+		for nodeID, names := range batch.Add {
+			for _, name := range names {
+				if _, ok := ids[name]; !ok {
+					var tag AdminTag
+					if err := tx.Where("name = ?", name).First(&tag).Error; err != nil {
+						return fmt.Errorf("error getting tag %v", err)
+					}
+					ids[name] = tag.ID
+				}
+				// Nodes tagged since the batch was planned are not tagged twice
+				var existing int64
+				if err := tx.Model(&TaggedNode{}).Where("tag = ? AND node_id = ?", name, nodeID).Count(&existing).Error; err != nil {
+					return fmt.Errorf("Count %v", err)
+				}
+				if existing > 0 {
+					continue
+				}
+				tagged := TaggedNode{
+					Tag:        name,
+					AdminTagID: ids[name],
+					NodeID:     nodeID,
+					UserID:     DefaultAutoTagUser,
+					TaggedBy:   job.CreatedBy,
+				}
+				if err := tx.Create(&tagged).Error; err != nil {
+					return fmt.Errorf("error tagging node %v", err)
+				}
+				changes = append(changes, tagMembership(batchNodes[nodeID], name, nodes.MembershipAdded, job.CreatedBy))
+			}
+		}
+		if err := nodes.RecordMembership(tx, changes...); err != nil {
+			return err
+		}
+		if len(batch.Conflicts) > 0 {
+			if err := tx.Create(&batch.Conflicts).Error; err != nil {
+				return fmt.Errorf("Create TagJobConflict %v", err)
+			}
+		}
+		if err := tx.Model(&TagJobNode{}).Where("job_id = ? AND node_id IN ?", job.ID, batch.NodeIDs).Update("done", true).Error; err != nil {
+			return fmt.Errorf("Update %v", err)
+		}
+		return nil
+	})
+}
+
+// Helper to get the ID and UUID of the nodes of a batch, to record their membership changes
+func nodesByID(tx *gorm.DB, nodeIDs []uint) (map[uint]nodes.OsqueryNode, error) {
+	res := make(map[uint]nodes.OsqueryNode)
+	if len(nodeIDs) == 0 {
+		return res, nil
+	}
+	var found []nodes.OsqueryNode
+	if err := tx.Select("id", "uuid").Where("id IN ?", nodeIDs).Find(&found).Error; err != nil {
+		return res, fmt.Errorf("Find %v", err)
+	}
+	for _, n := range found {
+		res[n.ID] = n
+	}
+	return res, nil
+}
+
+// FinishJob to set the final status of a job, as long as the owner still holds it
+func (m *TagManager) FinishJob(job TagJob, owner, status, errMsg string, now time.Time) error {
+	res := m.DB.Model(&TagJob{}).Where("id = ? AND owner = ?", job.ID, owner).Updates(map[string]interface{}{
+		"status":       status,
+		"error":        errMsg,
+		"completed_at": now,
+	})
+	if res.Error != nil {
+		return fmt.Errorf("Updates %v", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return ErrTagJobLost
+	}
+	return nil
+}
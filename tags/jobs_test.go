@@ -0,0 +1,250 @@
+package tags
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryJobStore keeps jobs and tags in memory, with the same semantics as the database
+type memoryJobStore struct {
+	jobs      map[uint]*TagJob
+	nodes     map[uint][]*TagJobNode
+	tagged    map[uint]map[string]bool
+	conflicts []TagJobConflict
+}
+
+func newMemoryJobStore() *memoryJobStore {
+	return &memoryJobStore{
+		jobs:   make(map[uint]*TagJob),
+		nodes:  make(map[uint][]*TagJobNode),
+		tagged: make(map[uint]map[string]bool),
+	}
+}
+
+func (s *memoryJobStore) create(job TagJob, nodeIDs []uint) uint {
+	job.ID = uint(len(s.jobs) + 1)
+	s.jobs[job.ID] = &job
+	for _, id := range nodeIDs {
+		s.nodes[job.ID] = append(s.nodes[job.ID], &TagJobNode{JobID: job.ID, NodeID: id})
+	}
+	return job.ID
+}
+
+func (s *memoryJobStore) tag(nodeID uint, name string) {
+	if s.tagged[nodeID] == nil {
+		s.tagged[nodeID] = make(map[string]bool)
+	}
+	s.tagged[nodeID][name] = true
+}
+
+func (s *memoryJobStore) ClaimJob(owner string, now time.Time, lease time.Duration) (TagJob, bool, error) {
+	for id := uint(1); id <= uint(len(s.jobs)); id++ {
+		job := s.jobs[id]
+		if job.Status == TagJobPending || (job.Status == TagJobRunning && (job.LeaseUntil.Before(now) || job.Owner == owner)) {
+			job.Status = TagJobRunning
+			job.Owner = owner
+			job.LeaseUntil = now.Add(lease)
+			return *job, true, nil
+		}
+	}
+	return TagJob{}, false, nil
+}
+
+func (s *memoryJobStore) PendingNodes(jobID uint, limit int) ([]uint, error) {
+	var ids []uint
+	for _, n := range s.nodes[jobID] {
+		if !n.Done && len(ids) < limit {
+			ids = append(ids, n.NodeID)
+		}
+	}
+	return ids, nil
+}
+
+func (s *memoryJobStore) TaggedNodes(nodeIDs []uint, tags []string) (map[uint]map[string]bool, error) {
+	res := make(map[uint]map[string]bool)
+	for _, id := range nodeIDs {
+		for _, t := range tags {
+			if s.tagged[id][t] {
+				if res[id] == nil {
+					res[id] = make(map[string]bool)
+				}
+				res[id][t] = true
+			}
+		}
+	}
+	return res, nil
+}
+
+func (s *memoryJobStore) OverlappingJobs(job TagJob, nodeIDs []uint) (map[uint]TagJob, map[uint][]uint, error) {
+	others := make(map[uint]TagJob)
+	othersNodes := make(map[uint][]uint)
+	for id, j := range s.jobs {
+		if id == job.ID || j.Finished() {
+			continue
+		}
+		for _, n := range s.nodes[id] {
+			for _, nodeID := range nodeIDs {
+				if n.NodeID == nodeID {
+					others[id] = *j
+					othersNodes[id] = append(othersNodes[id], nodeID)
+				}
+			}
+		}
+	}
+	return others, othersNodes, nil
+}
+
+func (s *memoryJobStore) ApplyBatch(job TagJob, owner string, batch TagBatch, leaseUntil time.Time) error {
+	j := s.jobs[job.ID]
+	if j.Owner != owner || j.Status != TagJobRunning {
+		return ErrTagJobLost
+	}
+	j.Processed += len(batch.NodeIDs)
+	j.Added += batch.Added
+	j.Removed += batch.Removed
+	j.AlreadyPresent += batch.AlreadyPresent
+	j.NotPresent += batch.NotPresent
+	j.Conflicts += len(batch.Conflicts)
+	j.LeaseUntil = leaseUntil
+	for nodeID, names := range batch.Remove {
+		for _, name := range names {
+			delete(s.tagged[nodeID], name)
+		}
+	}
+	for nodeID, names := range batch.Add {
+		for _, name := range names {
+			s.tag(nodeID, name)
+		}
+	}
+	s.conflicts = append(s.conflicts, batch.Conflicts...)
+	for _, n := range s.nodes[job.ID] {
+		for _, id := range batch.NodeIDs {
+			if n.NodeID == id {
+				n.Done = true
+			}
+		}
+	}
+	return nil
+}
+
+func (s *memoryJobStore) FinishJob(job TagJob, owner, status, errMsg string, now time.Time) error {
+	j := s.jobs[job.ID]
+	if j.Owner != owner {
+		return ErrTagJobLost
+	}
+	j.Status = status
+	j.Error = errMsg
+	j.CompletedAt = now
+	return nil
+}
+
+func batchSize(size int) func() int {
+	return func() int { return size }
+}
+
+func TestNewTagJob(t *testing.T) {
+	_, err := NewTagJob("admin", nil, nil, 10, 0, false)
+	assert.Error(t, err)
+	_, err = NewTagJob("admin", []string{"prod"}, nil, 0, 0, false)
+	assert.Error(t, err)
+	_, err = NewTagJob("admin", []string{"prod"}, nil, 11, 10, false)
+	var confirm *ErrTagJobConfirm
+	assert.True(t, errors.As(err, &confirm))
+	assert.Equal(t, 11, confirm.Total)
+	job, err := NewTagJob("admin", []string{"prod", "linux"}, []string{"dev"}, 11, 10, true)
+	assert.NoError(t, err)
+	assert.Equal(t, TagJobPending, job.Status)
+	assert.Equal(t, []string{"prod", "linux"}, SplitTags(job.TagsAdd))
+	assert.Equal(t, []string{"dev"}, SplitTags(job.TagsRemove))
+	job, err = NewTagJob("admin", nil, []string{"dev"}, 10, 10, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, job.Progress())
+}
+
+func TestTagJobRestart(t *testing.T) {
+	store := newMemoryJobStore()
+	store.tag(2, "prod")
+	store.tag(3, "dev")
+	job, err := NewTagJob("admin", []string{"prod"}, []string{"dev"}, 5, 0, false)
+	assert.NoError(t, err)
+	id := store.create(job, []uint{1, 2, 3, 4, 5})
+	now := time.Now()
+	first := NewTagJobRunner(store, batchSize(2))
+	first.Owner = "first"
+	more, err := first.Step(now)
+	assert.True(t, more)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, store.jobs[id].Processed)
+	assert.Equal(t, 40, store.jobs[id].Progress())
+	// The first instance stops, a new one can not take the job until the lease expires
+	second := NewTagJobRunner(store, batchSize(2))
+	second.Owner = "second"
+	more, err = second.Step(now.Add(time.Minute))
+	assert.False(t, more)
+	assert.NoError(t, err)
+	later := now.Add(DefaultTagJobLease + time.Second)
+	for more = true; more; more, err = second.Step(later) {
+		assert.NoError(t, err)
+	}
+	finished := store.jobs[id]
+	assert.Equal(t, TagJobCompleted, finished.Status)
+	assert.Equal(t, 5, finished.Processed)
+	assert.Equal(t, 4, finished.Added)
+	assert.Equal(t, 1, finished.AlreadyPresent)
+	assert.Equal(t, 1, finished.Removed)
+	assert.Equal(t, 4, finished.NotPresent)
+	for id := uint(1); id <= 5; id++ {
+		assert.True(t, store.tagged[id]["prod"])
+		assert.False(t, store.tagged[id]["dev"])
+	}
+	// The first instance lost the job and can not write to it anymore
+	first.job = &TagJob{}
+	first.job.ID = id
+	_, err = first.Step(later)
+	assert.True(t, errors.Is(err, ErrTagJobLost))
+}
+
+func TestTagJobConflicts(t *testing.T) {
+	store := newMemoryJobStore()
+	add, _ := NewTagJob("admin", []string{"prod"}, nil, 4, 0, false)
+	remove, _ := NewTagJob("other", nil, []string{"prod"}, 4, 0, false)
+	addID := store.create(add, []uint{1, 2, 3, 4})
+	removeID := store.create(remove, []uint{3, 4, 5, 6})
+	now := time.Now()
+	r1 := NewTagJobRunner(store, batchSize(2))
+	r1.Owner = "one"
+	r2 := NewTagJobRunner(store, batchSize(2))
+	r2.Owner = "two"
+	// Both jobs run at the same time, the second one processes the shared nodes last
+	for _, r := range []*TagJobRunner{r1, r1, r2, r2} {
+		_, err := r.Step(now)
+		assert.NoError(t, err)
+	}
+	for _, r := range []*TagJobRunner{r1, r2} {
+		more, err := r.Step(now)
+		assert.True(t, more)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, TagJobCompleted, store.jobs[addID].Status)
+	assert.Equal(t, TagJobCompleted, store.jobs[removeID].Status)
+	// Nodes 3 and 4 were tagged first and untagged last, the last write wins
+	assert.True(t, store.tagged[1]["prod"])
+	assert.True(t, store.tagged[2]["prod"])
+	assert.False(t, store.tagged[3]["prod"])
+	assert.False(t, store.tagged[4]["prod"])
+	assert.Equal(t, 4, store.jobs[addID].Added)
+	assert.Equal(t, 2, store.jobs[removeID].Removed)
+	assert.Equal(t, 2, store.jobs[removeID].NotPresent)
+	// Both jobs record the conflicts on the shared nodes
+	assert.Equal(t, 2, store.jobs[addID].Conflicts)
+	assert.Equal(t, 2, store.jobs[removeID].Conflicts)
+	assert.Equal(t, []TagJobConflict{
+		{JobID: addID, OtherJobID: removeID, NodeID: 3, Tag: "prod", Action: TagActionAdd},
+		{JobID: addID, OtherJobID: removeID, NodeID: 4, Tag: "prod", Action: TagActionAdd},
+		{JobID: removeID, OtherJobID: addID, NodeID: 3, Tag: "prod", Action: TagActionRemove},
+		{JobID: removeID, OtherJobID: addID, NodeID: 4, Tag: "prod", Action: TagActionRemove},
+	}, store.conflicts)
+}
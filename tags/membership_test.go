@@ -0,0 +1,168 @@
+package tags
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func mockTags(t *testing.T) (*TagManager, sqlmock.Sqlmock, func()) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db, got error: %v", err)
+	}
+	_postgres, err := gorm.Open(postgres.New(postgres.Config{Conn: mockDB}), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("unable to create new postgres database: %v", err)
+	}
+	return &TagManager{DB: _postgres}, mock, func() { mockDB.Close() }
+}
+
+func tagRow() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "name"}).AddRow(3, "cde")
+}
+
+func taggedNode() nodes.OsqueryNode {
+	n := nodes.OsqueryNode{UUID: "UUID1"}
+	n.ID = 7
+	return n
+}
+
+func TestTagNodeRecordsMembership(t *testing.T) {
+	m, mock, done := mockTags(t)
+	defer done()
+	mock.ExpectQuery(`SELECT \* FROM "admin_tags"`).WillReturnRows(tagRow())
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "tagged_nodes"`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "tagged_nodes"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectQuery(`INSERT INTO "node_memberships"`).
+		WithArgs(7, "UUID1", nodes.MembershipTag, "cde", nodes.MembershipAdded, "admin", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+	assert.NoError(t, m.TagNode("cde", taggedNode(), "admin", false))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTagNodeNotRecordedRollsBack(t *testing.T) {
+	m, mock, done := mockTags(t)
+	defer done()
+	mock.ExpectQuery(`SELECT \* FROM "admin_tags"`).WillReturnRows(tagRow())
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "tagged_nodes"`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "tagged_nodes"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectQuery(`INSERT INTO "node_memberships"`).WillReturnError(fmt.Errorf("insert failed"))
+	mock.ExpectRollback()
+	assert.Error(t, m.TagNode("cde", taggedNode(), "admin", false))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUntagNodeRecordsMembership(t *testing.T) {
+	m, mock, done := mockTags(t)
+	defer done()
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "admin_tags"`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(`SELECT \* FROM "tagged_nodes"`).WillReturnRows(sqlmock.NewRows([]string{"id", "tag", "node_id"}).AddRow(9, "cde", 7))
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM "tagged_nodes"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO "node_memberships"`).
+		WithArgs(7, "UUID1", nodes.MembershipTag, "cde", nodes.MembershipRemoved, "admin", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+	assert.NoError(t, m.UntagNode("cde", taggedNode(), "admin"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeleteRecordsMembership(t *testing.T) {
+	m, mock, done := mockTags(t)
+	defer done()
+	mock.ExpectQuery(`SELECT \* FROM "admin_tags"`).WillReturnRows(tagRow())
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT "node_id" FROM "tagged_nodes"`).WillReturnRows(sqlmock.NewRows([]string{"node_id"}).AddRow(7).AddRow(8))
+	mock.ExpectExec(`UPDATE "tagged_nodes" SET "deleted_at"`).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectQuery(`SELECT "id","uuid" FROM "osquery_nodes"`).WillReturnRows(sqlmock.NewRows([]string{"id", "uuid"}).AddRow(7, "UUID1").AddRow(8, "UUID2"))
+	mock.ExpectQuery(`INSERT INTO "node_memberships"`).
+		WithArgs(
+			7, "UUID1", nodes.MembershipTag, "cde", nodes.MembershipRemoved, "admin", sqlmock.AnyArg(),
+			8, "UUID2", nodes.MembershipTag, "cde", nodes.MembershipRemoved, "admin", sqlmock.AnyArg(),
+		).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+	mock.ExpectExec(`SAVEPOINT`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`UPDATE "admin_tags" SET "deleted_by"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE "admin_tags" SET "deleted_at"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	assert.NoError(t, m.Delete("cde", "admin"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRestoreRecordsMembership(t *testing.T) {
+	m, mock, done := mockTags(t)
+	defer done()
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "admin_tags"`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(`SELECT \* FROM "admin_tags" WHERE name = \$1 AND deleted_at IS NOT NULL`).WillReturnRows(tagRow())
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT "node_id" FROM "tagged_nodes" WHERE admin_tag_id = \$1 AND deleted_at IS NOT NULL`).WillReturnRows(sqlmock.NewRows([]string{"node_id"}).AddRow(7))
+	mock.ExpectExec(`UPDATE "tagged_nodes" SET "deleted_at"=\$1`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT "id","uuid" FROM "osquery_nodes"`).WillReturnRows(sqlmock.NewRows([]string{"id", "uuid"}).AddRow(7, "UUID1"))
+	mock.ExpectQuery(`INSERT INTO "node_memberships"`).
+		WithArgs(7, "UUID1", nodes.MembershipTag, "cde", nodes.MembershipAdded, "admin", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec(`UPDATE "admin_tags"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	assert.NoError(t, m.Restore("cde", "admin"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestHardDeleteRecordsMembership(t *testing.T) {
+	m, mock, done := mockTags(t)
+	defer done()
+	mock.ExpectQuery(`SELECT \* FROM "admin_tags"`).WillReturnRows(tagRow())
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT "node_id" FROM "tagged_nodes"`).WillReturnRows(sqlmock.NewRows([]string{"node_id"}).AddRow(7))
+	mock.ExpectExec(`DELETE FROM "tagged_nodes"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT "id","uuid" FROM "osquery_nodes"`).WillReturnRows(sqlmock.NewRows([]string{"id", "uuid"}).AddRow(7, "UUID1"))
+	mock.ExpectQuery(`INSERT INTO "node_memberships"`).
+		WithArgs(7, "UUID1", nodes.MembershipTag, "cde", nodes.MembershipRemoved, "admin", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec(`SAVEPOINT`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`DELETE FROM "admin_tags"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO "hard_deletions"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+	assert.NoError(t, m.HardDelete("cde", "admin"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestApplyBatchRecordsMembership(t *testing.T) {
+	m, mock, done := mockTags(t)
+	defer done()
+	job := TagJob{CreatedBy: "admin"}
+	job.ID = 1
+	batch := TagBatch{
+		NodeIDs: []uint{7},
+		Add:     map[uint][]string{7: {"cde"}},
+		Remove:  map[uint][]string{7: {"web", "old"}},
+	}
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "tag_jobs"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT "id","uuid" FROM "osquery_nodes"`).WillReturnRows(sqlmock.NewRows([]string{"id", "uuid"}).AddRow(7, "UUID1"))
+	// Only tags the node had are recorded as removed
+	mock.ExpectQuery(`SELECT "tag" FROM "tagged_nodes"`).WillReturnRows(sqlmock.NewRows([]string{"tag"}).AddRow("web"))
+	mock.ExpectExec(`DELETE FROM "tagged_nodes"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT \* FROM "admin_tags"`).WillReturnRows(tagRow())
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "tagged_nodes"`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(`INSERT INTO "tagged_nodes"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectQuery(`INSERT INTO "node_memberships"`).
+		WithArgs(
+			7, "UUID1", nodes.MembershipTag, "web", nodes.MembershipRemoved, "admin", sqlmock.AnyArg(),
+			7, "UUID1", nodes.MembershipTag, "cde", nodes.MembershipAdded, "admin", sqlmock.AnyArg(),
+		).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+	mock.ExpectExec(`UPDATE "tag_job_nodes"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	assert.NoError(t, m.ApplyBatch(job, "owner", batch, time.Now()))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
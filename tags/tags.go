@@ -5,6 +5,7 @@ import (
 	"log"
 	"strings"
 
+	"github.com/jmpsec/osctrl/backend"
 	"github.com/jmpsec/osctrl/nodes"
 	"gorm.io/gorm"
 )
@@ -26,6 +27,7 @@ type AdminTag struct {
 	Color       string
 	Icon        string
 	CreatedBy   string
+	DeletedBy   string
 }
 
 // AdminTagForNode to check if this tag is used for an specific node
@@ -62,6 +64,18 @@ func CreateTagManager(backend *gorm.DB) *TagManager {
 	if err := backend.AutoMigrate(&TaggedNode{}); err != nil {
 		log.Fatalf("Failed to AutoMigrate table (tagged_nodes): %v", err)
 	}
+	// table tag_jobs
+	if err := backend.AutoMigrate(&TagJob{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (tag_jobs): %v", err)
+	}
+	// table tag_job_nodes
+	if err := backend.AutoMigrate(&TagJobNode{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (tag_job_nodes): %v", err)
+	}
+	// table tag_job_conflicts
+	if err := backend.AutoMigrate(&TagJobConflict{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (tag_job_conflicts): %v", err)
+	}
 	return t
 }
 
@@ -141,16 +155,25 @@ func (m *TagManager) All() ([]AdminTag, error) {
 	return tags, nil
 }
 
-// Delete tag by name
-func (m *TagManager) Delete(name string) error {
+// Delete to move a tag to the trash by name, with the nodes it tags so restoring it tags them again
+func (m *TagManager) Delete(name, user string) error {
 	tag, err := m.Get(name)
 	if err != nil {
 		return fmt.Errorf("error getting tag %v", err)
 	}
-	if err := m.DB.Unscoped().Delete(&tag).Error; err != nil {
-		return fmt.Errorf("Delete %v", err)
-	}
-	return nil
+	return m.DB.Transaction(func(tx *gorm.DB) error {
+		var nodeIDs []uint
+		if err := tx.Model(&TaggedNode{}).Where("admin_tag_id = ?", tag.ID).Pluck("node_id", &nodeIDs).Error; err != nil {
+			return fmt.Errorf("Pluck %v", err)
+		}
+		if err := tx.Where("admin_tag_id = ?", tag.ID).Delete(&TaggedNode{}).Error; err != nil {
+			return fmt.Errorf("Delete %v", err)
+		}
+		if err := nodes.RecordNodesMembership(tx, nodeIDs, nodes.MembershipTag, tag.Name, nodes.MembershipRemoved, user); err != nil {
+			return err
+		}
+		return backend.SoftDelete(tx, &tag, user)
+	})
 }
 
 // ChangeDescription to update description for a tag
@@ -243,10 +266,24 @@ func (m *TagManager) TagNode(name string, node nodes.OsqueryNode, user string, a
 		UserID:     DefaultAutoTagUser,
 		TaggedBy:   user,
 	}
-	if err := m.DB.Create(&tagged).Error; err != nil {
-		return fmt.Errorf("error tagging node %v", err)
+	return m.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&tagged).Error; err != nil {
+			return fmt.Errorf("error tagging node %v", err)
+		}
+		return nodes.RecordMembership(tx, tagMembership(node, tag.Name, nodes.MembershipAdded, user))
+	})
+}
+
+// Helper to get the membership change of a tag for a node
+func tagMembership(node nodes.OsqueryNode, tag, action, user string) nodes.NodeMembership {
+	return nodes.NodeMembership{
+		NodeID: node.ID,
+		UUID:   node.UUID,
+		Entity: nodes.MembershipTag,
+		Name:   tag,
+		Action: action,
+		Actor:  user,
 	}
-	return nil
 }
 
 // IsTagged to check if a node is already tagged
@@ -264,8 +301,8 @@ func (m *TagManager) IsTaggedID(name string, nodeID uint) bool {
 	return (results > 0)
 }
 
-// UntagNode to untag a node
-func (m *TagManager) UntagNode(name string, node nodes.OsqueryNode) error {
+// UntagNode to untag a node, by the user removing the tag
+func (m *TagManager) UntagNode(name string, node nodes.OsqueryNode, user string) error {
 	if !m.Exists(name) {
 		return fmt.Errorf("tag does not exist")
 	}
@@ -273,10 +310,12 @@ func (m *TagManager) UntagNode(name string, node nodes.OsqueryNode) error {
 	if err := m.DB.Where("tag = ? AND node_id = ?", name, node.ID).First(&tagged).Error; err != nil {
 		return fmt.Errorf("TaggedNode %v", err)
 	}
-	if err := m.DB.Unscoped().Delete(&tagged).Error; err != nil {
-		return fmt.Errorf("Delete %v", err)
-	}
-	return nil
+	return m.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Delete(&tagged).Error; err != nil {
+			return fmt.Errorf("Delete %v", err)
+		}
+		return nodes.RecordMembership(tx, tagMembership(node, name, nodes.MembershipRemoved, user))
+	})
 }
 
 // GetTags to retrieve the tags of a given node
@@ -299,6 +338,31 @@ func (m *TagManager) GetTags(node nodes.OsqueryNode) ([]AdminTag, error) {
 	return tags, nil
 }
 
+// GetTagNames to retrieve the names of the tags of a given node
+func (m *TagManager) GetTagNames(node nodes.OsqueryNode) ([]string, error) {
+	var names []string
+	if err := m.DB.Model(&TaggedNode{}).Where("node_id = ? AND tag <> ''", node.ID).Pluck("tag", &names).Error; err != nil {
+		return names, err
+	}
+	return names, nil
+}
+
+// TagNamesOfNodes to retrieve the names of the tags of many nodes, by node ID
+func (m *TagManager) TagNamesOfNodes(nodeIDs []uint) (map[uint][]string, error) {
+	res := make(map[uint][]string)
+	if len(nodeIDs) == 0 {
+		return res, nil
+	}
+	var tagged []TaggedNode
+	if err := m.DB.Where("node_id IN ? AND tag <> ''", nodeIDs).Find(&tagged).Error; err != nil {
+		return res, err
+	}
+	for _, t := range tagged {
+		res[t.NodeID] = append(res[t.NodeID], t.Tag)
+	}
+	return res, nil
+}
+
 // GetNodeTags to decorate tags for a given node
 func (m *TagManager) GetNodeTags(tagged []AdminTag) ([]AdminTagForNode, error) {
 	var tags []AdminTag
@@ -0,0 +1,109 @@
+package tags
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jmpsec/osctrl/backend"
+	"github.com/jmpsec/osctrl/nodes"
+	"gorm.io/gorm"
+)
+
+// Trash to get the tags deleted after the cutoff, newest first
+func (m *TagManager) Trash(cutoff time.Time) ([]AdminTag, error) {
+	var tags []AdminTag
+	if err := m.DB.Scopes(backend.InTrash(cutoff)).Find(&tags).Error; err != nil {
+		return tags, err
+	}
+	return tags, nil
+}
+
+// Restore to take the last deleted tag with a name out of the trash, tagging again the nodes it had
+func (m *TagManager) Restore(name, user string) error {
+	if m.Exists(name) {
+		return fmt.Errorf("tag %s already exists", name)
+	}
+	var tag AdminTag
+	if err := m.DB.Unscoped().Where("name = ? AND deleted_at IS NOT NULL", name).Order("deleted_at DESC").First(&tag).Error; err != nil {
+		return fmt.Errorf("tag %s is not in the trash: %v", name, err)
+	}
+	return m.DB.Transaction(func(tx *gorm.DB) error {
+		// Nodes tagged again while the tag was in the trash are not tagged twice
+		tagged := tx.Model(&TaggedNode{}).Select("node_id").Where("tag = ?", name)
+		restored := func() *gorm.DB {
+			return tx.Unscoped().Model(&TaggedNode{}).Where("admin_tag_id = ? AND deleted_at IS NOT NULL AND node_id NOT IN (?)", tag.ID, tagged)
+		}
+		var nodeIDs []uint
+		if err := restored().Pluck("node_id", &nodeIDs).Error; err != nil {
+			return fmt.Errorf("Pluck %v", err)
+		}
+		if err := restored().Update("deleted_at", nil).Error; err != nil {
+			return fmt.Errorf("Update %v", err)
+		}
+		if err := nodes.RecordNodesMembership(tx, nodeIDs, nodes.MembershipTag, name, nodes.MembershipAdded, user); err != nil {
+			return err
+		}
+		return backend.Restore(tx, &tag)
+	})
+}
+
+// HardDelete to delete a tag permanently by name, in the trash or not, with the nodes it tags
+func (m *TagManager) HardDelete(name, user string) error {
+	var tags []AdminTag
+	if err := m.DB.Unscoped().Where("name = ?", name).Find(&tags).Error; err != nil {
+		return fmt.Errorf("error getting tag %v", err)
+	}
+	if len(tags) == 0 {
+		return fmt.Errorf("tag %s not found", name)
+	}
+	return m.DB.Transaction(func(tx *gorm.DB) error {
+		for i := range tags {
+			// Nodes of tags in the trash were already untagged when it was deleted
+			var nodeIDs []uint
+			if err := tx.Model(&TaggedNode{}).Where("admin_tag_id = ?", tags[i].ID).Pluck("node_id", &nodeIDs).Error; err != nil {
+				return fmt.Errorf("Pluck %v", err)
+			}
+			if err := tx.Unscoped().Where("admin_tag_id = ?", tags[i].ID).Delete(&TaggedNode{}).Error; err != nil {
+				return fmt.Errorf("Delete %v", err)
+			}
+			if err := nodes.RecordNodesMembership(tx, nodeIDs, nodes.MembershipTag, name, nodes.MembershipRemoved, user); err != nil {
+				return err
+			}
+			if err := backend.HardDelete(tx, &tags[i], backend.TrashTags, name, user); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// PurgeTrash to delete permanently the tags deleted before the cutoff, with the nodes they tagged
+func (m *TagManager) PurgeTrash(cutoff time.Time) (int64, error) {
+	var purged int64
+	err := m.DB.Transaction(func(tx *gorm.DB) error {
+		expired := tx.Model(&AdminTag{}).Scopes(backend.Expired(cutoff)).Select("id")
+		if err := tx.Unscoped().Where("admin_tag_id IN (?)", expired).Delete(&TaggedNode{}).Error; err != nil {
+			return fmt.Errorf("Delete %v", err)
+		}
+		res := tx.Scopes(backend.Expired(cutoff)).Delete(&AdminTag{})
+		if res.Error != nil {
+			return fmt.Errorf("Delete %v", res.Error)
+		}
+		purged = res.RowsAffected
+		return nil
+	})
+	return purged, err
+}
+
+// TrashItems to get the tags in the trash
+func (m *TagManager) TrashItems(days int, now time.Time) ([]backend.TrashItem, error) {
+	items := []backend.TrashItem{}
+	trashed, err := m.Trash(backend.TrashCutoff(days, now))
+	if err != nil {
+		return items, err
+	}
+	for _, t := range trashed {
+		items = append(items, backend.NewTrashItem(backend.TrashTags, t.Name, t.Description, t.DeletedBy, t.DeletedAt, days))
+	}
+	return items, nil
+}
@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"regexp"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+const (
+	// DefaultMaxBodySmall is the default maximum size in MB of bodies for enroll, config, query read,
+	// carve init and osctrld requests
+	DefaultMaxBodySmall = 1
+	// DefaultMaxBody is the default maximum size in MB of bodies for log and query write requests
+	DefaultMaxBody = 128
+	// DefaultMaxBodyCarve is the default maximum size in MB of bodies for carve block requests
+	DefaultMaxBodyCarve = 16
+	// Bytes of the body read to find the node_key of requests over the limit
+	bodyKeyPrefix = 4096
+)
+
+const (
+	metricBodyTooLarge = "body-too-large"
+)
+
+// ErrBodyTooLarge when the body of a request goes over the maximum size of its endpoint
+var ErrBodyTooLarge = errors.New("request body too large")
+
+// Expression to find the node_key in the first bytes of a body, it may be truncated
+var nodeKeyRegexp = regexp.MustCompile(`"node_key"\s*:\s*"([^"]*)"`)
+
+// BodyLimits to hold the maximum size in MB of request bodies for each class of endpoints
+type BodyLimits struct {
+	Small int64
+	Logs  int64
+	Carve int64
+}
+
+// NewBodyLimits to get the maximum sizes of request bodies, defaults if not set
+func NewBodyLimits(small, logs, carve int64) BodyLimits {
+	limits := BodyLimits{Small: small, Logs: logs, Carve: carve}
+	if limits.Small <= 0 {
+		limits.Small = DefaultMaxBodySmall
+	}
+	if limits.Logs <= 0 {
+		limits.Logs = DefaultMaxBody
+	}
+	if limits.Carve <= 0 {
+		limits.Carve = DefaultMaxBodyCarve
+	}
+	return limits
+}
+
+// For to get the maximum size in bytes of the body for an endpoint. Endpoints of osctrld are small.
+func (l BodyLimits) For(endpoint string) int64 {
+	switch endpoint {
+	case environments.EndpointLog, environments.EndpointQueryWrite:
+		return l.Logs * 1024 * 1024
+	case environments.EndpointCarverBlock:
+		return l.Carve * 1024 * 1024
+	}
+	return l.Small * 1024 * 1024
+}
+
+// WithBodyLimits to pass value as option
+func WithBodyLimits(limits BodyLimits) Option {
+	return func(h *HandlersTLS) {
+		h.BodyLimits = limits
+	}
+}
+
+// Helper to get the maximum sizes of request bodies, from settings or the configured ones
+func (h *HandlersTLS) bodyLimits() BodyLimits {
+	limits := NewBodyLimits(h.BodyLimits.Small, h.BodyLimits.Logs, h.BodyLimits.Carve)
+	if h.SettingsMap != nil {
+		if s, ok := (*h.SettingsMap)[settings.MaxBodySmall]; ok && s.Integer > 0 {
+			limits.Small = s.Integer
+		}
+		if s, ok := (*h.SettingsMap)[settings.MaxBody]; ok && s.Integer > 0 {
+			limits.Logs = s.Integer
+		}
+		if s, ok := (*h.SettingsMap)[settings.MaxBodyCarve]; ok && s.Integer > 0 {
+			limits.Carve = s.Integer
+		}
+	}
+	return limits
+}
+
+// Reader to keep the first bytes of a body limited by http.MaxBytesReader and know if it went over the limit
+type limitedBody struct {
+	io.ReadCloser
+	prefix   []byte
+	exceeded bool
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if left := bodyKeyPrefix - len(b.prefix); left > 0 {
+		if left > n {
+			left = n
+		}
+		b.prefix = append(b.prefix, p[:left]...)
+	}
+	// http.MaxBytesReader does not have its own type of error
+	if err != nil && err.Error() == "http: request body too large" {
+		b.exceeded = true
+		return n, ErrBodyTooLarge
+	}
+	return n, err
+}
+
+// Writer to know if a response was sent, so requests over the limit get one
+type sentWriter struct {
+	http.ResponseWriter
+	sent bool
+}
+
+func (w *sentWriter) WriteHeader(status int) {
+	w.sent = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *sentWriter) Write(b []byte) (int, error) {
+	w.sent = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Helper to get the node_key from the first bytes of a body
+func bodyNodeKey(prefix []byte) string {
+	if m := nodeKeyRegexp.FindSubmatch(prefix); m != nil {
+		return string(m[1])
+	}
+	return "unknown"
+}
+
+// Helper to reject a request with a body over the limit, logged with the node_key and environment
+func (h *HandlersTLS) bodyTooLarge(w http.ResponseWriter, r *http.Request, endpoint string, limit int64, prefix []byte) {
+	h.Inc(metricBodyTooLarge)
+	log.Printf("%s body over the limit of %d bytes from node_key %s in environment %s (%s)", endpoint, limit, bodyNodeKey(prefix), mux.Vars(r)["environment"], utils.GetIP(r))
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusRequestEntityTooLarge, TLSResponse{Message: "request body too large"})
+}
+
+// LimitBody to wrap the handler of an endpoint so it can not read bodies over the maximum size of the endpoint.
+// Requests announcing a bigger body are rejected before reading it, the rest once they go over the limit.
+func (h *HandlersTLS) LimitBody(endpoint string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Body == nil {
+			next(w, r)
+			return
+		}
+		limit := h.bodyLimits().For(endpoint)
+		if r.ContentLength > limit {
+			prefix, _ := ioutil.ReadAll(io.LimitReader(r.Body, bodyKeyPrefix))
+			h.bodyTooLarge(w, r, endpoint, limit, prefix)
+			return
+		}
+		body := &limitedBody{ReadCloser: http.MaxBytesReader(w, r.Body, limit)}
+		r.Body = body
+		sw := &sentWriter{ResponseWriter: w}
+		next(sw, r)
+		if !body.exceeded {
+			return
+		}
+		// Handlers that do not know the error of the limit only log it, the response is sent here
+		if sw.sent {
+			h.Inc(metricBodyTooLarge)
+			log.Printf("%s body over the limit of %d bytes from node_key %s in environment %s", endpoint, limit, bodyNodeKey(body.prefix), mux.Vars(r)["environment"])
+			return
+		}
+		h.bodyTooLarge(w, r, endpoint, limit, body.prefix)
+	}
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBodyLimitsFor(t *testing.T) {
+	limits := NewBodyLimits(0, 0, 0)
+	assert.Equal(t, int64(DefaultMaxBodySmall*1024*1024), limits.For(environments.EndpointEnroll))
+	assert.Equal(t, int64(DefaultMaxBodySmall*1024*1024), limits.For(environments.DefaultFlagsPath))
+	assert.Equal(t, int64(DefaultMaxBody*1024*1024), limits.For(environments.EndpointLog))
+	assert.Equal(t, int64(DefaultMaxBody*1024*1024), limits.For(environments.EndpointQueryWrite))
+	assert.Equal(t, int64(DefaultMaxBodyCarve*1024*1024), limits.For(environments.EndpointCarverBlock))
+}
+
+func TestBodyLimitsSettings(t *testing.T) {
+	settingsmap := settings.MapSettings{settings.MaxBodyCarve: settings.SettingValue{Integer: 2}}
+	h := CreateHandlersTLS(WithSettingsMap(&settingsmap), WithBodyLimits(NewBodyLimits(3, 0, 0)))
+	limits := h.bodyLimits()
+	assert.Equal(t, int64(3), limits.Small)
+	assert.Equal(t, int64(DefaultMaxBody), limits.Logs)
+	assert.Equal(t, int64(2), limits.Carve)
+}
+
+func TestBodyNodeKey(t *testing.T) {
+	assert.Equal(t, "abc", bodyNodeKey([]byte(`{"log_type":"result", "node_key" : "abc","data":[`)))
+	assert.Equal(t, "unknown", bodyNodeKey([]byte(`{"data":[{"name":`)))
+}
+
+func TestLimitBody(t *testing.T) {
+	h := CreateHandlersTLS(WithBodyLimits(NewBodyLimits(1, 0, 0)))
+	var read int
+	// Handler reading the body that only logs errors, like most of the handlers
+	handler := h.LimitBody(environments.EndpointConfig, func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		read = len(body)
+		if err != nil {
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	})
+	big := `{"node_key":"abc","data":"` + strings.Repeat("a", 1024*1024) + `"}`
+	// Bodies under the limit are read
+	req := httptest.NewRequest("POST", "/dev/config", strings.NewReader(`{"node_key":"abc"}`))
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "ok", rr.Body.String())
+	// Bodies announced over the limit are rejected before reading them
+	read = 0
+	req = httptest.NewRequest("POST", "/dev/config", strings.NewReader(big))
+	rr = httptest.NewRecorder()
+	handler(rr, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+	assert.Contains(t, rr.Body.String(), "request body too large")
+	assert.Equal(t, 0, read)
+	// Bodies without length are rejected once they go over the limit
+	req = httptest.NewRequest("POST", "/dev/config", ioutil.NopCloser(bytes.NewBufferString(big)))
+	req.ContentLength = -1
+	rr = httptest.NewRecorder()
+	handler(rr, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+	assert.Equal(t, 1024*1024, read)
+}
+
+func TestLimitBodyHandlerResponse(t *testing.T) {
+	h := CreateHandlersTLS(WithBodyLimits(NewBodyLimits(1, 1, 1)))
+	// Handlers that know the error of the limit send their own response
+	handler := h.LimitBody(environments.EndpointLog, func(w http.ResponseWriter, r *http.Request) {
+		if _, err := ioutil.ReadAll(r.Body); err == ErrBodyTooLarge {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			_, _ = w.Write([]byte("log body too large"))
+		}
+	})
+	req := httptest.NewRequest("POST", "/dev/log", strings.NewReader(strings.Repeat("a", 2*1024*1024)))
+	req.ContentLength = -1
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+	assert.Equal(t, "log body too large", rr.Body.String())
+}
@@ -1,9 +1,13 @@
 package handlers
 
 import (
+	"fmt"
 	"log"
+	"time"
 
 	"github.com/jmpsec/osctrl/carves"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/nodes"
 	"github.com/jmpsec/osctrl/settings"
 	"github.com/jmpsec/osctrl/types"
 )
@@ -57,20 +61,93 @@ func (h *HandlersTLS) ProcessCarveInit(req types.CarveInitRequest, sessionid, en
 	return nil
 }
 
-// ProcessCarveBlock - Function to process one block from a file carve
+// Helper to check a carve init against the carve limits of the environment. Rejected carves are marked with the
+// reason and audited with the user that requested them. Limits that can not be checked do not reject carves.
+func (h *HandlersTLS) carveRejected(env environments.TLSEnvironment, node nodes.OsqueryNode, req types.CarveInitRequest) bool {
+	limits, err := h.Carves.GetLimits(env.ID)
+	if err != nil {
+		log.Printf("error getting carve limits %v", err)
+		return false
+	}
+	if limits.Unlimited() {
+		return false
+	}
+	carve, err := h.Carves.GetByCarve(req.CarveID)
+	if err != nil {
+		log.Printf("error getting carve %v", err)
+	}
+	var limit string
+	var reason error
+	if pattern, denied := limits.PathDenied(carve.Path); denied {
+		overridden, err := h.Carves.PathOverridden(env.ID, carve.QueryName)
+		if err != nil {
+			log.Printf("error getting carve override %v", err)
+		}
+		if !overridden {
+			limit, reason = carves.LimitPath, fmt.Errorf("path %s is denied by %s", carve.Path, pattern)
+		}
+	}
+	if limit == "" {
+		now := time.Now()
+		nodeSessions, err := h.Carves.NodeSessions(node.UUID, now)
+		if err != nil {
+			log.Printf("error counting carve sessions %v", err)
+		}
+		envSessions, err := h.Carves.EnvSessions(env.ID, now)
+		if err != nil {
+			log.Printf("error counting carve sessions %v", err)
+		}
+		daily, err := h.Carves.DailyBytes(env.ID, now)
+		if err != nil {
+			log.Printf("error getting carve bytes %v", err)
+		}
+		limit, reason = limits.CheckInit(int64(req.CarveSize), nodeSessions, envSessions, daily)
+	}
+	if limit == "" {
+		return false
+	}
+	h.Inc(metricInitReject)
+	var requester string
+	if carve.QueryName != "" {
+		if q, err := h.Queries.Get(carve.QueryName, env.ID); err == nil {
+			requester = q.Creator
+		}
+	}
+	if carve.ID != 0 {
+		if err := h.Carves.RejectCarve(carve, req.CarveSize, reason.Error()); err != nil {
+			log.Printf("error rejecting carve %v", err)
+		}
+	}
+	rejection := carves.CarveRejection{
+		EnvironmentID: env.ID,
+		Limit:         limit,
+		UUID:          node.UUID,
+		QueryName:     carve.QueryName,
+		Path:          carve.Path,
+		Size:          int64(req.CarveSize),
+		Requester:     requester,
+		Detail:        reason.Error(),
+	}
+	if err := h.Carves.RecordRejection(rejection); err != nil {
+		log.Printf("error recording carve rejection %v", err)
+	}
+	return true
+}
+
+// ProcessCarveBlock - Function to process one block from a file carve, it is safe to run again for the same block
 // FIXME it can be more efficient on db access
-func (h *HandlersTLS) ProcessCarveBlock(req types.CarveBlockRequest, environment, uuid string, envid uint) {
+func (h *HandlersTLS) ProcessCarveBlock(req types.CarveBlockRequest, environment, uuid string, envid uint) error {
 	// Initiate carve block
 	block := h.Carves.InitateBlock(environment, uuid, req.RequestID, req.SessionID, req.Data, req.BlockID, envid)
-	// Create Block
+	// Create Block, if it is not stored it does not count as received
 	if err := h.Carves.CreateBlock(block, uuid, req.Data); err != nil {
 		h.Inc(metricBlockErr)
-		log.Printf("error creating CarvedBlock %v", err)
+		return fmt.Errorf("error creating CarvedBlock %v", err)
 	}
 	// Bump block completion
 	if err := h.Carves.CompleteBlock(req.SessionID); err != nil {
 		h.Inc(metricBlockErr)
-		log.Printf("error completing block %v", err)
+		return fmt.Errorf("error completing block %v", err)
 	}
 	// If it is completed, set status
 	if h.Carves.Completed(req.SessionID) {
@@ -80,12 +157,12 @@ func (h *HandlersTLS) ProcessCarveBlock(req types.CarveBlockRequest, environment
 			if err != nil {
 				h.Inc(metricBlockErr)
 				log.Printf("error archiving results %v", err)
-				return
+				return nil
 			}
 			if archived == nil {
 				h.Inc(metricBlockErr)
 				log.Printf("empty archive %v", err)
-				return
+				return nil
 			}
 			if err := h.Carves.ArchiveCarve(req.SessionID, archived.File); err != nil {
 				h.Inc(metricBlockErr)
@@ -95,6 +172,19 @@ func (h *HandlersTLS) ProcessCarveBlock(req types.CarveBlockRequest, environment
 		if err := h.Carves.ChangeStatus(carves.StatusCompleted, req.SessionID); err != nil {
 			h.Inc(metricBlockErr)
 			log.Printf("error completing carve %v", err)
+			return nil
+		}
+		// Index the files of the completed carve in the background
+		if err := h.Carves.StartManifest(req.SessionID); err != nil {
+			h.Inc(metricBlockErr)
+			log.Printf("error indexing carve %v", err)
+		}
+		// Scan the completed carve in the background, if there is a scanner
+		if scanner := carves.ScannerFromSettings(h.Settings); scanner != nil {
+			if err := h.Carves.StartScan(scanner, req.SessionID); err != nil {
+				h.Inc(metricBlockErr)
+				log.Printf("error scanning carve %v", err)
+			}
 		}
 	} else {
 		if err := h.Carves.ChangeStatus(carves.StatusInProgress, req.SessionID); err != nil {
@@ -102,4 +192,39 @@ func (h *HandlersTLS) ProcessCarveBlock(req types.CarveBlockRequest, environment
 			log.Printf("error progressing carve %v", err)
 		}
 	}
+	return nil
+}
+
+// StartCarveQueue to store carve blocks asynchronously with a pool of workers sized for the carver
+func (h *HandlersTLS) StartCarveQueue(cfg carves.BlockQueueConfig) {
+	h.CarveQueue = carves.CreateBlockQueue(cfg, h.Carves.Carver, func(job carves.BlockJob) error {
+		return h.ProcessCarveBlock(job.Request, job.Environment, job.UUID, job.EnvID)
+	})
+	h.CarveQueue.Start()
+}
+
+// StopCarveQueue to store pending carve blocks before shutting down
+func (h *HandlersTLS) StopCarveQueue(timeout time.Duration) error {
+	if h.CarveQueue == nil {
+		return nil
+	}
+	return h.CarveQueue.Shutdown(timeout)
+}
+
+// EnqueueCarveBlock to queue one block to be stored by the workers
+func (h *HandlersTLS) EnqueueCarveBlock(req types.CarveBlockRequest, environment, uuid string, envid uint) error {
+	if h.CarveQueue == nil {
+		go func() {
+			if err := h.ProcessCarveBlock(req, environment, uuid, envid); err != nil {
+				log.Printf("error processing carve block %v", err)
+			}
+		}()
+		return nil
+	}
+	return h.CarveQueue.Enqueue(carves.BlockJob{
+		Request:     req,
+		Environment: environment,
+		UUID:        uuid,
+		EnvID:       envid,
+	})
 }
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/jmpsec/osctrl/settings"
+)
+
+const (
+	// DefaultDecompressMaxBody is the default maximum size in bytes of compressed bodies once decompressed
+	DefaultDecompressMaxBody = 512 * 1024 * 1024
+)
+
+// ErrDecompressedTooLarge when a compressed body goes over the maximum size once decompressed
+var ErrDecompressedTooLarge = errors.New("decompressed body too large")
+
+// Magic bytes of gzip, osquery does not always set Content-Encoding when it compresses
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// Reader to count the bytes of a body as received, before decompressing it
+type wireReader struct {
+	r    io.Reader
+	read int64
+}
+
+func (w *wireReader) Read(p []byte) (int, error) {
+	n, err := w.r.Read(p)
+	w.read += int64(n)
+	return n, err
+}
+
+// Reader to fail as soon as a decompressed body goes over the maximum
+type decompressedReader struct {
+	r    io.Reader
+	max  int64
+	read int64
+}
+
+func (d *decompressedReader) Read(p []byte) (int, error) {
+	if d.read > d.max {
+		return 0, ErrDecompressedTooLarge
+	}
+	if left := d.max + 1 - d.read; int64(len(p)) > left {
+		p = p[:left]
+	}
+	n, err := d.r.Read(p)
+	d.read += int64(n)
+	if d.read > d.max {
+		return n, ErrDecompressedTooLarge
+	}
+	return n, err
+}
+
+// requestBody to hold the body of a request as it is read, decompressed if it was compressed
+type requestBody struct {
+	io.Reader
+	closer     io.Closer
+	wire       *wireReader
+	Compressed bool
+}
+
+// Close to close the original body of the request
+func (b *requestBody) Close() error {
+	return b.closer.Close()
+}
+
+// Wire to get the bytes of the body received so far, compressed or not
+func (b *requestBody) Wire() int {
+	return int(b.wire.read)
+}
+
+// Helper to get the body of a request, decompressed when it is compressed with gzip by the header or
+// by its magic bytes. Decompressed bodies fail with ErrDecompressedTooLarge over the maximum size.
+func newRequestBody(r *http.Request, maxSize int64) (*requestBody, error) {
+	wire := &wireReader{r: r.Body}
+	buffered := bufio.NewReader(wire)
+	body := &requestBody{Reader: buffered, closer: r.Body, wire: wire}
+	compressed := strings.EqualFold(strings.TrimSpace(r.Header.Get("Content-Encoding")), "gzip")
+	if !compressed {
+		magic, _ := buffered.Peek(len(gzipMagic))
+		compressed = bytes.Equal(magic, gzipMagic)
+	}
+	if !compressed {
+		return body, nil
+	}
+	zr, err := gzip.NewReader(buffered)
+	if err != nil {
+		return nil, err
+	}
+	if maxSize <= 0 {
+		maxSize = DefaultDecompressMaxBody
+	}
+	body.Reader = &decompressedReader{r: zr, max: maxSize}
+	body.Compressed = true
+	return body, nil
+}
+
+// Helper to get the maximum size of compressed bodies once decompressed, from settings or default
+func (h *HandlersTLS) decompressMaxBody() int64 {
+	if h.SettingsMap != nil {
+		if s, ok := (*h.SettingsMap)[settings.DecompressMaxBody]; ok && s.Integer > 0 {
+			return s.Integer * 1024 * 1024
+		}
+	}
+	return DefaultDecompressMaxBody
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func gzipBody(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatalf("error compressing body %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("error compressing body %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestRequestBody(t *testing.T) {
+	payload := []byte(`{"node_key": "abc", "log_type": "result", "data": [` + strings.Repeat(`{"name": "uptime"},`, 100) + `{}]}`)
+	compressed := gzipBody(t, payload)
+	// Plain bodies are read as they are
+	req, _ := http.NewRequest("POST", "/dev/log", bytes.NewReader(payload))
+	body, err := newRequestBody(req, 0)
+	assert.NoError(t, err)
+	data, err := io.ReadAll(body)
+	assert.NoError(t, err)
+	assert.Equal(t, payload, data)
+	assert.False(t, body.Compressed)
+	assert.Equal(t, len(payload), body.Wire())
+	// Compressed bodies with and without Content-Encoding
+	for _, header := range []string{"gzip", ""} {
+		req, _ = http.NewRequest("POST", "/dev/log", bytes.NewReader(compressed))
+		req.Header.Set("Content-Encoding", header)
+		body, err = newRequestBody(req, 0)
+		assert.NoError(t, err)
+		data, err = io.ReadAll(body)
+		assert.NoError(t, err)
+		assert.Equal(t, payload, data)
+		assert.True(t, body.Compressed)
+		assert.Equal(t, len(compressed), body.Wire())
+		assert.Less(t, body.Wire(), len(payload))
+		assert.NoError(t, body.Close())
+	}
+	// Bodies that are not gzip with the header
+	req, _ = http.NewRequest("POST", "/dev/log", bytes.NewReader(payload))
+	req.Header.Set("Content-Encoding", "gzip")
+	_, err = newRequestBody(req, 0)
+	assert.Error(t, err)
+}
+
+func TestRequestBodyTooLarge(t *testing.T) {
+	// Small on the wire, too large once decompressed
+	compressed := gzipBody(t, bytes.Repeat([]byte("0"), 1024*1024))
+	req, _ := http.NewRequest("POST", "/dev/write", bytes.NewReader(compressed))
+	body, err := newRequestBody(req, 1024)
+	assert.NoError(t, err)
+	data, err := io.ReadAll(body)
+	assert.ErrorIs(t, err, ErrDecompressedTooLarge)
+	assert.LessOrEqual(t, len(data), 1025)
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/nodes"
+)
+
+// How long the denylist of an environment is cached before reading it again from the DB
+const denylistCacheTTL = 60 * time.Second
+
+// denylistCache to keep the scheduled query denylist of environments in memory
+type denylistCache struct {
+	mux  sync.Mutex
+	envs map[uint]cachedDenylist
+}
+
+// cachedDenylist to keep the denylist of one environment until it expires
+type cachedDenylist struct {
+	entries []environments.ScheduleDeny
+	expires time.Time
+}
+
+// Helper to get the denylist of an environment, from the cache if it did not expire
+func (h *HandlersTLS) envDenylist(ctx context.Context, env environments.TLSEnvironment) []environments.ScheduleDeny {
+	now := time.Now()
+	h.denylist.mux.Lock()
+	cached, ok := h.denylist.envs[env.ID]
+	h.denylist.mux.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.entries
+	}
+	entries, err := h.Envs.ScheduleDenylistCtx(ctx, env.ID)
+	if err != nil {
+		// Nodes keep the denylist they had
+		log.Printf("error getting denylist %v", err)
+		return cached.entries
+	}
+	h.denylist.mux.Lock()
+	if h.denylist.envs == nil {
+		h.denylist.envs = make(map[uint]cachedDenylist)
+	}
+	h.denylist.envs[env.ID] = cachedDenylist{entries: entries, expires: now.Add(denylistCacheTTL)}
+	h.denylist.mux.Unlock()
+	return entries
+}
+
+// Helper to get the entries of the denylist of an environment that apply to a node
+func (h *HandlersTLS) nodeDenylist(ctx context.Context, env environments.TLSEnvironment, node nodes.OsqueryNode) []environments.ScheduleDeny {
+	entries := h.envDenylist(ctx, env)
+	if len(entries) == 0 {
+		return nil
+	}
+	pNode := environments.ProfileNode{Platform: node.Platform}
+	if h.Tags != nil && environments.DenylistUsesTags(entries) {
+		tags, err := h.Tags.GetTagNames(node)
+		if err != nil {
+			log.Printf("error getting tags for denylist %v", err)
+		}
+		pNode.Tags = tags
+	}
+	return environments.NodeDenylist(entries, pNode, time.Now())
+}
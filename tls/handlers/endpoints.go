@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/environments"
+)
+
+const (
+	metricPathErr = "path-err"
+)
+
+// Helper to get the handlers of the osquery endpoints, with the maximum size of the body of each one
+func (h *HandlersTLS) osqueryEndpoints() map[string]http.HandlerFunc {
+	return map[string]http.HandlerFunc{
+		environments.EndpointEnroll:      h.LimitBody(environments.EndpointEnroll, h.EnrollHandler),
+		environments.EndpointLog:         h.LimitBody(environments.EndpointLog, h.LogHandler),
+		environments.EndpointConfig:      h.LimitBody(environments.EndpointConfig, h.ConfigHandler),
+		environments.EndpointQueryRead:   h.LimitBody(environments.EndpointQueryRead, h.QueryReadHandler),
+		environments.EndpointQueryWrite:  h.LimitBody(environments.EndpointQueryWrite, h.QueryWriteHandler),
+		environments.EndpointCarverInit:  h.LimitBody(environments.EndpointCarverInit, h.CarveInitHandler),
+		environments.EndpointCarverBlock: h.LimitBody(environments.EndpointCarverBlock, h.CarveBlockHandler),
+	}
+}
+
+// Helper to get an environment by name or UUID, from the map of environments first and the DB for new ones
+func (h *HandlersTLS) endpointEnv(r *http.Request, envVar string) (environments.TLSEnvironment, error) {
+	if h.EnvsMap != nil {
+		if env, ok := (*h.EnvsMap)[envVar]; ok {
+			return env, nil
+		}
+	}
+	return h.Envs.GetCtx(r.Context(), envVar)
+}
+
+// Helper to send a request to the handler of the osquery endpoint served in its path, 404 if no endpoint uses it
+func (h *HandlersTLS) dispatchEndpoint(w http.ResponseWriter, r *http.Request, endpoints map[string]http.HandlerFunc) {
+	vars := mux.Vars(r)
+	env, err := h.endpointEnv(r, vars["environment"])
+	if err != nil {
+		h.Inc(metricPathErr)
+		log.Printf("error getting environment %v", err)
+		backendErrorResponse(w, r, err)
+		return
+	}
+	endpoint, ok := env.Paths().Endpoint(vars["path"])
+	if !ok {
+		h.Inc(metricPathErr)
+		http.NotFound(w, r)
+		return
+	}
+	endpoints[endpoint](w, r)
+}
+
+// OsqueryHandler - Function to handle POST requests of osquery nodes, each environment serves
+// the osquery endpoints in its own paths
+func (h *HandlersTLS) OsqueryHandler(w http.ResponseWriter, r *http.Request) {
+	h.dispatchEndpoint(w, r, h.osqueryEndpoints())
+}
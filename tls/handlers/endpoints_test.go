@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDispatchEndpoint(t *testing.T) {
+	envsmap := environments.MapEnvironments{
+		"dev":  environments.TLSEnvironment{Name: "dev", EnrollPath: "join", LogPath: "dev-log"},
+		"prod": environments.TLSEnvironment{Name: "prod", EnrollPath: "prod-enroll"},
+	}
+	h := CreateHandlersTLS(WithEnvsMap(&envsmap))
+	endpoints := make(map[string]http.HandlerFunc)
+	for _, endpoint := range []string{environments.EndpointEnroll, environments.EndpointLog, environments.EndpointConfig, environments.EndpointQueryRead, environments.EndpointQueryWrite, environments.EndpointCarverInit, environments.EndpointCarverBlock} {
+		endpoint := endpoint
+		endpoints[endpoint] = func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(mux.Vars(r)["environment"] + ":" + endpoint))
+		}
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/{environment}/{path}", func(w http.ResponseWriter, r *http.Request) {
+		h.dispatchEndpoint(w, r, endpoints)
+	}).Methods("POST")
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/dev/join", http.StatusOK, "dev:enroll"},
+		{"/prod/prod-enroll", http.StatusOK, "prod:enroll"},
+		{"/dev/dev-log", http.StatusOK, "dev:log"},
+		{"/prod/log", http.StatusOK, "prod:log"},
+		{"/dev/config", http.StatusOK, "dev:config"},
+		// Paths of other environments or the defaults replaced are not found
+		{"/dev/prod-enroll", http.StatusNotFound, ""},
+		{"/prod/join", http.StatusNotFound, ""},
+		{"/dev/" + environments.DefaultEnrollPath, http.StatusNotFound, ""},
+		{"/dev/log", http.StatusNotFound, ""},
+	}
+	// Both environments are served at the same time
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		for _, tt := range tests {
+			wg.Add(1)
+			go func(path string, status int, body string) {
+				defer wg.Done()
+				req, _ := http.NewRequest("POST", path, nil)
+				rr := httptest.NewRecorder()
+				router.ServeHTTP(rr, req)
+				assert.Equal(t, status, rr.Code, path)
+				if status == http.StatusOK {
+					assert.Equal(t, body, rr.Body.String(), path)
+				}
+			}(tt.path, tt.status, tt.body)
+		}
+	}
+	wg.Wait()
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+const (
+	metricEnrollLoop    = "enroll-loop"
+	metricEnrollBackoff = "enroll-backoff"
+)
+
+// Helper to get the configuration of enroll loops of an environment
+func enrollLoopConfig(env environments.TLSEnvironment) nodes.EnrollLoopConfig {
+	return nodes.NewEnrollLoopConfig(env.EnrollLoopLimit, env.EnrollLoopQuiet, env.EnrollBackoff)
+}
+
+// Helper to generate the enroll event of an enroll request
+func enrollEventFromRequest(req types.EnrollRequest, env environments.TLSEnvironment, ipaddress string, now time.Time) nodes.EnrollEvent {
+	startTime, _ := strconv.ParseInt(req.HostDetails.EnrollOsqueryInfo.StartTime, 10, 64)
+	return nodes.EnrollEvent{
+		UUID:           req.HostIdentifier,
+		EnvironmentID:  env.ID,
+		Secret:         nodes.SecretFingerprint(req.EnrollSecret),
+		OsqueryVersion: req.HostDetails.EnrollOsqueryInfo.Version,
+		ConfigHash:     req.HostDetails.EnrollOsqueryInfo.ConfigHash,
+		InstanceID:     req.HostDetails.EnrollOsqueryInfo.InstanceID,
+		StartTime:      startTime,
+		IPAddress:      ipaddress,
+		CreatedAt:      now,
+	}
+}
+
+// Helper to record an enroll and flag hosts that enroll in a loop
+func (h *HandlersTLS) recordEnroll(req types.EnrollRequest, env environments.TLSEnvironment, ipaddress string) {
+	event := enrollEventFromRequest(req, env, ipaddress, time.Now())
+	loop, err := h.Nodes.RecordEnroll(event, req.HostDetails.EnrollSystemInfo.Hostname, enrollLoopConfig(env))
+	if err != nil {
+		log.Printf("error recording enroll %v", err)
+		return
+	}
+	if loop.Looping {
+		h.Inc(metricEnrollLoop)
+		if (*h.EnvsMap)[env.Name].DebugHTTP {
+			log.Printf("Node %s in %s is looping with %d enrolls", event.UUID, env.Name, loop.Enrolls)
+		}
+	}
+}
+
+// Helper to send a backoff response to hosts that enroll in a loop, instead of enrolling them again.
+// Returns true if the response was sent.
+func (h *HandlersTLS) enrollBackoff(w http.ResponseWriter, env environments.TLSEnvironment, uuid string) bool {
+	if !env.EnrollBackoff {
+		return false
+	}
+	wait, err := h.Nodes.EnrollBlocked(uuid, env.ID, time.Now())
+	if err != nil {
+		log.Printf("error checking enroll loop %v", err)
+		return false
+	}
+	if wait <= 0 {
+		return false
+	}
+	h.Inc(metricEnrollBackoff)
+	retry := int((wait + time.Second - 1) / time.Second)
+	if (*h.EnvsMap)[env.Name].DebugHTTP {
+		log.Printf("Node %s in %s is looping, retry after %d seconds", uuid, env.Name, retry)
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(retry))
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusTooManyRequests, types.EnrollResponse{NodeInvalid: true})
+	return true
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestEnrollEventFromRequest(t *testing.T) {
+	var req types.EnrollRequest
+	req.EnrollSecret = "secret"
+	req.HostIdentifier = "abc"
+	req.HostDetails.EnrollOsqueryInfo.Version = "5.2.2"
+	req.HostDetails.EnrollOsqueryInfo.InstanceID = "instance"
+	req.HostDetails.EnrollOsqueryInfo.StartTime = "1646748000"
+	now := time.Now()
+	e := enrollEventFromRequest(req, environments.TLSEnvironment{Model: gorm.Model{ID: 1}}, "10.0.0.1", now)
+	assert.Equal(t, uint(1), e.EnvironmentID)
+	assert.Equal(t, nodes.SecretFingerprint("secret"), e.Secret)
+	assert.NotContains(t, e.Secret, "secret")
+	assert.Equal(t, int64(1646748000), e.StartTime)
+	assert.Equal(t, now, e.CreatedAt)
+}
+
+func TestEnrollHandlerLoopBackoff(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db, got error: %v", err)
+	}
+	defer mockDB.Close()
+	_postgres, err := gorm.Open(postgres.New(postgres.Config{Conn: mockDB}), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("unable to create new postgres database: %v", err)
+	}
+	mock.MatchExpectationsInOrder(false)
+	mock.ExpectQuery(`SELECT \* FROM "tls_environments"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "secret", "accept_enrolls", "enroll_backoff"}).AddRow(1, "dev", "secret", true, true))
+	mock.ExpectQuery(`SELECT "uuid" FROM "node_decommissions"`).WillReturnRows(sqlmock.NewRows([]string{"uuid"}))
+	mock.ExpectQuery(`SELECT \* FROM "enroll_fields"`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	// The looping host waits for another 90 seconds
+	mock.ExpectQuery(`SELECT \* FROM "enroll_loops"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "uuid", "looping", "blocked_until"}).AddRow(1, "ABC", true, time.Now().Add(90*time.Second)))
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "enroll_loops" SET "blocked"=blocked \+ 1`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	envsmap := environments.MapEnvironments{"dev": environments.TLSEnvironment{Name: "dev"}}
+	h := CreateHandlersTLS(WithEnvs(&environments.Environment{DB: _postgres}), WithNodes(&nodes.NodeManager{DB: _postgres}), WithEnvsMap(&envsmap))
+	req, _ := http.NewRequest("POST", "/dev/enroll", strings.NewReader(`{"enroll_secret": "secret", "host_identifier": "abc"}`))
+	req = mux.SetURLVars(req, map[string]string{"environment": "dev"})
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(h.EnrollHandler).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "90", rr.Header().Get("Retry-After"))
+	assert.JSONEq(t, `{"node_key": "", "node_invalid": true}`, rr.Body.String())
+	// No node is created or archived while the host waits
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
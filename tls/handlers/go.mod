@@ -8,6 +8,8 @@ replace github.com/jmpsec/osctrl/carves => ../../carves
 
 replace github.com/jmpsec/osctrl/environments => ../../environments
 
+replace github.com/jmpsec/osctrl/locales => ../../locales
+
 replace github.com/jmpsec/osctrl/logging => ../../logging
 
 replace github.com/jmpsec/osctrl/metrics => ../../metrics
@@ -16,6 +18,8 @@ replace github.com/jmpsec/osctrl/nodes => ../../nodes
 
 replace github.com/jmpsec/osctrl/queries => ../../queries
 
+replace github.com/jmpsec/osctrl/responses => ../../responses
+
 replace github.com/jmpsec/osctrl/settings => ../../settings
 
 replace github.com/jmpsec/osctrl/tags => ../../tags
@@ -31,10 +35,12 @@ require (
 	github.com/jmpsec/osctrl/backend v0.3.1 // indirect
 	github.com/jmpsec/osctrl/carves v0.0.0-20220120232002-31ecf3b9f264
 	github.com/jmpsec/osctrl/environments v0.0.0-20220120232002-31ecf3b9f264
+	github.com/jmpsec/osctrl/locales v0.3.1
 	github.com/jmpsec/osctrl/logging v0.0.0-20220120232002-31ecf3b9f264
 	github.com/jmpsec/osctrl/metrics v0.0.0-20220120232002-31ecf3b9f264
 	github.com/jmpsec/osctrl/nodes v0.3.1
 	github.com/jmpsec/osctrl/queries v0.3.1
+	github.com/jmpsec/osctrl/responses v0.3.1
 	github.com/jmpsec/osctrl/settings v0.3.1
 	github.com/jmpsec/osctrl/tags v0.0.0-20220120232002-31ecf3b9f264
 	github.com/jmpsec/osctrl/types v0.3.1
@@ -1,21 +1,26 @@
 package handlers
 
 import (
-	"compress/gzip"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/backend"
 	"github.com/jmpsec/osctrl/carves"
 	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/locales"
 	"github.com/jmpsec/osctrl/logging"
 	"github.com/jmpsec/osctrl/metrics"
 	"github.com/jmpsec/osctrl/nodes"
 	"github.com/jmpsec/osctrl/queries"
+	"github.com/jmpsec/osctrl/responses"
 	"github.com/jmpsec/osctrl/settings"
 	"github.com/jmpsec/osctrl/tags"
 	"github.com/jmpsec/osctrl/types"
@@ -30,6 +35,7 @@ const (
 	metricLogReq      = "log-req"
 	metricLogErr      = "log-err"
 	metricLogOK       = "log-ok"
+	metricLogDrop     = "log-drop"
 	metricConfigReq   = "config-req"
 	metricConfigErr   = "config-err"
 	metricConfigOK    = "config-ok"
@@ -39,9 +45,11 @@ const (
 	metricWriteReq    = "write-req"
 	metricWriteErr    = "write-err"
 	metricWriteOK     = "write-ok"
+	metricWriteDrop   = "write-drop"
 	metricInitReq     = "init-req"
 	metricInitErr     = "init-err"
 	metricInitOK      = "init-ok"
+	metricInitReject  = "init-reject"
 	metricBlockReq    = "block-req"
 	metricBlockErr    = "block-err"
 	metricBlockOK     = "block-ok"
@@ -67,6 +75,8 @@ const (
 const (
 	// osquery version
 	defOsqueryVersion = version.OsqueryVersion
+	// Header with the version of the served certificate
+	certVersionHeader = "X-Osctrl-Certificate-Version"
 )
 
 // Valid values for actions in handlers
@@ -90,11 +100,22 @@ type HandlersTLS struct {
 	Tags        *tags.TagManager
 	Queries     *queries.Queries
 	Carves      *carves.Carves
+	CarveQueue  *carves.BlockQueue
 	Settings    *settings.Settings
 	SettingsMap *settings.MapSettings
 	Metrics     *metrics.Metrics
 	Ingested    *metrics.IngestedManager
 	Logs        *logging.LoggerTLS
+	Storm       *StormGuard
+	Responses   *responses.ResponseManager
+	Requests    *metrics.RequestStats
+	BodyLimits  BodyLimits
+	BuildInfo   version.BuildInfo
+	profiles    profilesCache
+	winEvents   windowsEventsCache
+	denylist    denylistCache
+	lineage     lineageCache
+	identities  identityChecks
 }
 
 // TLSResponse to be returned to requests
@@ -182,6 +203,34 @@ func WithLogs(logs *logging.LoggerTLS) Option {
 	}
 }
 
+// WithStorm to pass value as option
+func WithStorm(storm *StormGuard) Option {
+	return func(h *HandlersTLS) {
+		h.Storm = storm
+	}
+}
+
+// WithResponses to pass value as option
+func WithResponses(responses *responses.ResponseManager) Option {
+	return func(h *HandlersTLS) {
+		h.Responses = responses
+	}
+}
+
+// WithRequestStats to pass value as option
+func WithRequestStats(requests *metrics.RequestStats) Option {
+	return func(h *HandlersTLS) {
+		h.Requests = requests
+	}
+}
+
+// WithBuildInfo to pass value as option
+func WithBuildInfo(info version.BuildInfo) Option {
+	return func(h *HandlersTLS) {
+		h.BuildInfo = info
+	}
+}
+
 // CreateHandlersTLS to initialize the TLS handlers struct
 func CreateHandlersTLS(opts ...Option) *HandlersTLS {
 	h := &HandlersTLS{}
@@ -212,6 +261,14 @@ func (h *HandlersTLS) HealthHandler(w http.ResponseWriter, r *http.Request) {
 	h.Inc(metricHealthOK)
 }
 
+// VersionHandler for version and build requests, without configuration details
+func (h *HandlersTLS) VersionHandler(w http.ResponseWriter, r *http.Request) {
+	h.Inc(metricHealthReq)
+	// Send response
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusOK, h.BuildInfo)
+	h.Inc(metricHealthOK)
+}
+
 // ErrorHandler for error requests
 func (h *HandlersTLS) ErrorHandler(w http.ResponseWriter, r *http.Request) {
 	// Send response
@@ -230,12 +287,15 @@ func (h *HandlersTLS) EnrollHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// Get environment
-	env, err := h.Envs.Get(envVar)
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
 	if err != nil {
 		h.Inc(metricEnrollErr)
 		log.Printf("error getting environment %v", err)
+		backendErrorResponse(w, r, err)
 		return
 	}
+	// Enrolls count for the storm rate but are never throttled
+	h.stormObserve(env)
 	// Check if environment accept enrolls
 	if !env.AcceptEnrolls {
 		h.Inc(metricEnrollErr)
@@ -261,10 +321,35 @@ func (h *HandlersTLS) EnrollHandler(w http.ResponseWriter, r *http.Request) {
 	var nodeKey string
 	var newNode nodes.OsqueryNode
 	nodeInvalid := true
-	if h.checkValidSecret(t.EnrollSecret, env) {
+	validSecret := h.checkValidSecret(t.EnrollSecret, env)
+	var metadata map[string]string
+	var metadataErr error
+	if validSecret {
+		metadata, metadataErr = h.enrollMetadata(r.Context(), t.EnrollSecret, env)
+	}
+	platformAllowed := environments.PlatformAllowed(env.AllowedPlatforms, t.HostDetails.EnrollOSVersion.Platform)
+	if validSecret && h.checkDecommissioned(t.HostIdentifier) {
+		// Decommissioned nodes are not enrolled again, until they are archived
+		h.Inc(metricEnrollErr)
+		log.Printf("not enrolling decommissioned node %s", t.HostIdentifier)
+	} else if validSecret && metadataErr != nil {
+		// Nodes with missing or invalid enroll metadata are not enrolled
+		h.Inc(metricEnrollErr)
+		log.Printf("not enrolling node %s %v", t.HostIdentifier, metadataErr)
+	} else if validSecret && !platformAllowed && h.platformPolicy() == environments.PlatformPolicyReject {
+		// Nodes with platforms not allowed in the environment are not enrolled
+		h.Inc(metricEnrollErr)
+		log.Printf("not enrolling node %s with platform %s", t.HostIdentifier, t.HostDetails.EnrollOSVersion.Platform)
+		h.recordRejection(nodeFromEnroll(t, env, utils.GetIP(r), "", len(body)), env, nodes.RejectionRejected)
+	} else if validSecret && h.enrollBackoff(w, env, t.HostIdentifier) {
+		// Hosts enrolling in a loop wait before enrolling again, instead of creating more churn
+		return
+	} else if validSecret {
+		h.recordEnroll(t, env, utils.GetIP(r))
 		// Generate node_key using UUID as entropy
 		nodeKey = generateNodeKey(t.HostIdentifier, time.Now())
 		newNode = nodeFromEnroll(t, env, utils.GetIP(r), nodeKey, len(body))
+		newNode.EnrollMetadata = nodes.EncodeEnrollMetadata(metadata)
 		// Check if UUID exists already, if so archive node and enroll new node
 		if h.Nodes.CheckByUUIDEnv(t.HostIdentifier, env.Name) {
 			if err := h.Nodes.Archive(t.HostIdentifier, "exists"); err != nil {
@@ -277,6 +362,8 @@ func (h *HandlersTLS) EnrollHandler(w http.ResponseWriter, r *http.Request) {
 				log.Printf("error updating existing node %v", err)
 			} else {
 				nodeInvalid = false
+				h.materializeNode(t.HostIdentifier)
+				h.bindIdentity(env, t.HostIdentifier)
 			}
 		} else { // New node, persist it
 			if err := h.Nodes.Create(&newNode); err != nil {
@@ -284,12 +371,19 @@ func (h *HandlersTLS) EnrollHandler(w http.ResponseWriter, r *http.Request) {
 				log.Printf("error creating node %v", err)
 			} else {
 				nodeInvalid = false
+				h.materializeNode(t.HostIdentifier)
+				h.bindIdentity(env, t.HostIdentifier)
 				if err := h.Tags.AutoTagNode(env.Name, newNode, "osctrl-tls"); err != nil {
 					h.Inc(metricEnrollErr)
 					log.Printf("error tagging node %v", err)
 				}
 			}
 		}
+		// Nodes with platforms not allowed are enrolled in quarantine until approved
+		if !nodeInvalid && !platformAllowed {
+			log.Printf("node %s with platform %s enrolled in quarantine", t.HostIdentifier, newNode.Platform)
+			h.recordRejection(newNode, env, nodes.RejectionPending)
+		}
 	} else {
 		h.Inc(metricEnrollErr)
 		log.Printf("error invalid enrolling secret %s", t.EnrollSecret)
@@ -317,12 +411,14 @@ func (h *HandlersTLS) ConfigHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// Get environment
-	env, err := h.Envs.Get(envVar)
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
 	if err != nil {
 		h.Inc(metricConfigErr)
 		log.Printf("error getting environment %v", err)
+		backendErrorResponse(w, r, err)
 		return
 	}
+	storm := h.stormObserve(env)
 	// Debug HTTP for environment
 	utils.DebugHTTPDump(r, (*h.EnvsMap)[env.Name].DebugHTTP, true)
 	// Decode read POST body
@@ -339,7 +435,7 @@ func (h *HandlersTLS) ConfigHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// Check if provided node_key is valid and if so, update node
-	if node, err := h.Nodes.GetByKey(t.NodeKey); err == nil {
+	if node, err := h.nodeByKey(r.Context(), t.NodeKey); err == nil {
 		ip := utils.GetIP(r)
 		if err := h.Nodes.RecordIPAddress(ip, node); err != nil {
 			h.Inc(metricConfigErr)
@@ -355,9 +451,80 @@ func (h *HandlersTLS) ConfigHandler(w http.ResponseWriter, r *http.Request) {
 			h.Inc(metricConfigErr)
 			log.Printf("error with ingested config %v", err)
 		}
-		response = []byte(env.Configuration)
-	} else {
+		conf := []byte(env.Configuration)
+		// Nodes in a performance profile get its intervals, nodes without profile use the environment ones
+		var profile environments.PerformanceProfile
+		if p, ok := h.nodeProfile(r.Context(), env, node); ok {
+			profileConf, err := h.profileConfig(env, p)
+			if err != nil {
+				h.Inc(metricConfigErr)
+				log.Printf("error applying performance profile %v", err)
+			} else {
+				conf = profileConf
+				profile = p
+			}
+		}
+		// Windows nodes get the scheduled queries of the enabled event channels, queries and packs in the denylist
+		// are removed wherever they are defined, and nodes with schedule overrides get their own intervals
+		inputs := environments.NodeInputs{
+			Platform: node.Platform,
+			Profile:  profile,
+			Channels: h.nodeChannels(r.Context(), env, node),
+			Denylist: h.nodeDenylist(r.Context(), env, node),
+		}
+		overrides, err := h.Envs.NodeScheduleOverridesCtx(r.Context(), node.UUID)
+		if err != nil {
+			h.Inc(metricConfigErr)
+			log.Printf("error getting schedule overrides %v", err)
+		}
+		inputs.Overrides = overrides
+		nodeConf, err := environments.RenderNodeConfig(conf, inputs)
+		if err != nil {
+			h.Inc(metricConfigErr)
+			log.Printf("error rendering node configuration %v", err)
+		}
+		conf = nodeConf
+		// Changes of the inputs of the node are kept in its lineage, to render again what it was served
+		h.recordLineage(env, node.UUID, inputs)
+		// During a storm part of the nodes get longer intervals to spread their check-ins
+		if backoff := h.stormBackoff(env, storm, t.NodeKey); backoff != nil {
+			nodeConf, err := environments.ApplyStormBackoff(conf, *backoff)
+			if err != nil {
+				h.Inc(metricConfigErr)
+				log.Printf("error applying storm backoff %v", err)
+			} else {
+				h.Inc(metricStormBackoff)
+				conf = nodeConf
+			}
+		}
+		// Environments with identity binding get the decorator with the characteristics of the host
+		if identityPolicy(env) != nodes.IdentityPolicyOff {
+			nodeConf, err := environments.IdentityDecoratorConfig(conf)
+			if err != nil {
+				h.Inc(metricConfigErr)
+				log.Printf("error applying identity decorator %v", err)
+			} else {
+				conf = nodeConf
+			}
+		}
+		// Quarantined nodes get the configuration without schedule and packs until approved
+		if h.checkQuarantined(node.UUID) {
+			nodeConf, err := environments.QuarantineConfig(conf)
+			if err != nil {
+				h.Inc(metricConfigErr)
+				log.Printf("error applying quarantine %v", err)
+			} else {
+				conf = nodeConf
+			}
+		}
+		response = conf
+	} else if backend.IsNotFound(err) {
 		response = types.ConfigResponse{NodeInvalid: true}
+	} else {
+		h.Inc(metricConfigErr)
+		log.Printf("error getting node %v", err)
+		backendErrorResponse(w, r, err)
+		return
 	}
 	// Debug HTTP
 	if (*h.EnvsMap)[env.Name].DebugHTTP {
@@ -384,60 +551,96 @@ func (h *HandlersTLS) LogHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// Get environment
-	env, err := h.Envs.Get(envVar)
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
 	if err != nil {
 		h.Inc(metricLogErr)
 		log.Printf("error getting environment %v", err)
+		backendErrorResponse(w, r, err)
 		return
 	}
-	// Check if body is compressed, if so, uncompress
-	if r.Header.Get("Content-Encoding") == "gzip" {
-		r.Body, err = gzip.NewReader(r.Body)
-		if err != nil {
-			h.Inc(metricLogErr)
-			log.Printf("error decoding gzip body %v", err)
-		}
-		defer func() {
-			if err := r.Body.Close(); err != nil {
-				h.Inc(metricLogErr)
-				log.Printf("Failed to close body %v", err)
-			}
-		}()
-	}
-	// Debug HTTP here so the body will be uncompressed
-	utils.DebugHTTPDump(r, (*h.EnvsMap)[env.Name].DebugHTTP, true)
-	// Extract POST body and decode JSON
-	var t types.LogRequest
-	body, err := ioutil.ReadAll(r.Body)
+	// Logs count for the storm rate but are never throttled
+	h.stormObserve(env)
+	// Check if body is compressed, if so, uncompress. Osquery does not always set Content-Encoding
+	body, err := newRequestBody(r, h.decompressMaxBody())
 	if err != nil {
 		h.Inc(metricLogErr)
-		log.Printf("error reading POST body %v", err)
-		return
-	}
-	if err := json.Unmarshal(body, &t); err != nil {
-		h.Inc(metricLogErr)
-		log.Printf("error parsing POST body %v", err)
+		log.Printf("error decoding gzip body %v", err)
+		utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusBadRequest, TLSResponse{Message: "error decoding compressed body"})
 		return
 	}
+	r.Body = body
+	// Debug HTTP here so the body will be uncompressed
+	utils.DebugHTTPDump(r, (*h.EnvsMap)[env.Name].DebugHTTP, true)
 	defer func() {
 		if err := r.Body.Close(); err != nil {
 			h.Inc(metricLogErr)
 			log.Printf("Failed to close body %v", err)
 		}
 	}()
-	var nodeInvalid bool
-	// Check if provided node_key is valid and if so, update node
-	node, err := h.Nodes.GetByKey(t.NodeKey); if err == nil {
-		nodeInvalid = false
-		// Record ingested data
-		if err := h.Ingested.IngestLog(env.ID, node.ID, len(body), t.LogType); err != nil {
+	// Decode POST body incrementally, entries are dispatched in chunks as they are parsed
+	var node nodes.OsqueryNode
+	var nodeInvalid, identityChecked bool
+	var logType string
+	var wireSeen int
+	var nodeErr error
+	debug := (*h.EnvsMap)[env.Name].DebugHTTP
+	ip := utils.GetIP(r)
+	res, err := logging.StreamLogRequest(r.Body, h.logStreamConfig(), func(nodeKey, lType string) error {
+		// Check if provided node_key is valid before any entry is dispatched
+		logType = lType
+		node, nodeErr = h.nodeByKey(r.Context(), nodeKey)
+		if backend.IsNotFound(nodeErr) {
+			nodeInvalid = true
+		}
+		return nodeErr
+	}, func(c logging.LogChunk) error {
+		// Record ingested data, with the bytes received for this chunk when the body is compressed
+		wire := len(c.Data)
+		if body.Compressed {
+			wire = body.Wire() - wireSeen
+			wireSeen += wire
+		}
+		if err := h.Ingested.IngestLog(env.ID, node.ID, len(c.Data), wire, logType); err != nil {
 			h.Inc(metricLogErr)
 			log.Printf("error with ingested log %v", err)
 		}
 		// Process logs and update metadata
-		go h.Logs.ProcessLogs(t.Data, t.LogType, env.Name, utils.GetIP(r), len(body), (*h.EnvsMap)[env.Name].DebugHTTP)
-	} else {
-		nodeInvalid = true
+		if err := h.Logs.EnqueueLogs(c.Data, logType, env.Name, ip, len(c.Data), debug); err != nil {
+			h.Inc(metricLogDrop)
+			log.Printf("error queueing logs %v", err)
+		}
+		// Identity of the node is checked with the decorations of its logs, once in a while
+		if !identityChecked && identityPolicy(env) != nodes.IdentityPolicyOff && h.identityDue(node.UUID) {
+			go h.checkIdentity(env, node, c.Data)
+		}
+		identityChecked = true
+		// Results may fire response actions for the node
+		if h.Responses != nil && logType == types.ResultLog {
+			go h.Responses.ProcessResults(node, c.Data)
+		}
+		return nil
+	})
+	switch {
+	case nodeInvalid:
+	case nodeErr != nil:
+		h.Inc(metricLogErr)
+		log.Printf("error getting node %v", nodeErr)
+		backendErrorResponse(w, r, nodeErr)
+		return
+	case errors.Is(err, logging.ErrLogBodyTooLarge), errors.Is(err, ErrDecompressedTooLarge), errors.Is(err, ErrBodyTooLarge):
+		// Entries dispatched before going over the limit are kept
+		h.Inc(metricLogErr)
+		log.Printf("log body over the limit after %d bytes, %d entries dispatched", res.Read, res.Entries)
+		utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusRequestEntityTooLarge, TLSResponse{Message: "log body too large"})
+		return
+	case err != nil:
+		h.Inc(metricLogErr)
+		log.Printf("error parsing POST body at byte %d, %d entries dispatched %v", res.Offset, res.Entries, err)
+		utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusBadRequest, TLSResponse{Message: fmt.Sprintf("error parsing logs at byte %d, %d entries processed", res.Offset, res.Entries)})
+		return
+	}
+	if res.Skipped > 0 {
+		log.Printf("%d log entries from %s skipped, they are not objects", res.Skipped, node.UUID)
 	}
 	// Prepare response
 	response := types.LogResponse{NodeInvalid: nodeInvalid}
@@ -462,10 +665,11 @@ func (h *HandlersTLS) QueryReadHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// Get environment
-	env, err := h.Envs.Get(envVar)
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
 	if err != nil {
 		h.Inc(metricReadErr)
 		log.Printf("error getting environment %v", err)
+		backendErrorResponse(w, r, err)
 		return
 	}
 	// Debug HTTP
@@ -483,10 +687,15 @@ func (h *HandlersTLS) QueryReadHandler(w http.ResponseWriter, r *http.Request) {
 		log.Printf("error parsing POST body %v", err)
 		return
 	}
+	// During a storm part of the nodes are asked to come back later
+	if backoff := h.stormBackoff(env, h.stormObserve(env), t.NodeKey); backoff != nil {
+		h.stormThrottle(w, env, backoff.QueryInterval)
+		return
+	}
 	var nodeInvalid, accelerate bool
 	qs := make(queries.QueryReadQueries)
 	// Check if provided node_key is valid and if so, update node
-	if node, err := h.Nodes.GetByKey(t.NodeKey); err == nil {
+	if node, err := h.nodeByKey(r.Context(), t.NodeKey); err == nil {
 		// Record ingested data
 		if err := h.Ingested.IngestQueryRead(env.ID, node.ID, len(body)); err != nil {
 			h.Inc(metricReadErr)
@@ -498,20 +707,28 @@ func (h *HandlersTLS) QueryReadHandler(w http.ResponseWriter, r *http.Request) {
 			log.Printf("error recording IP address %v", err)
 		}
 		nodeInvalid = false
-		qs, accelerate, err = h.Queries.NodeQueries(node)
-		if err != nil {
-			h.Inc(metricReadErr)
-			log.Printf("error getting queries from db %v", err)
+		// Quarantined nodes do not get on-demand queries until approved, neither nodes with identity mismatch when enforced
+		// nor paused nodes, their queries stay pending until unpaused
+		if !h.checkQuarantined(node.UUID) && !h.identityBlocked(env, node.UUID) && !h.checkPaused(node.UUID) {
+			qs, accelerate, err = h.Queries.NodeQueriesCtx(r.Context(), node)
+			if err != nil {
+				h.Inc(metricReadErr)
+				log.Printf("error getting queries from db %v", err)
+			}
 		}
 		// Refresh last query read request
 		if err := h.Nodes.QueryReadRefresh(node, ip, len(body)); err != nil {
 			h.Inc(metricReadErr)
 			log.Printf("error refreshing last query read %v", err)
 		}
-	} else {
-		log.Printf("GetByKey %v", err)
+	} else if backend.IsNotFound(err) {
 		nodeInvalid = true
 		accelerate = false
+	} else {
+		h.Inc(metricReadErr)
+		log.Printf("GetByKey %v", err)
+		backendErrorResponse(w, r, err)
+		return
 	}
 	// Prepare response and serialize queries
 	var response interface{}
@@ -542,17 +759,35 @@ func (h *HandlersTLS) QueryWriteHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	// Get environment
-	env, err := h.Envs.Get(envVar)
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
 	if err != nil {
 		h.Inc(metricWriteErr)
 		log.Printf("error getting environment %v", err)
+		backendErrorResponse(w, r, err)
 		return
 	}
-	// Debug HTTP
+	// Query results count for the storm rate but are never throttled
+	h.stormObserve(env)
+	// Check if body is compressed, if so, uncompress. Osquery does not always set Content-Encoding
+	reqBody, err := newRequestBody(r, h.decompressMaxBody())
+	if err != nil {
+		h.Inc(metricWriteErr)
+		log.Printf("error decoding gzip body %v", err)
+		utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusBadRequest, TLSResponse{Message: "error decoding compressed body"})
+		return
+	}
+	r.Body = reqBody
+	// Debug HTTP here so the body will be uncompressed
 	utils.DebugHTTPDump(r, (*h.EnvsMap)[env.Name].DebugHTTP, true)
 	// Decode read POST body
 	var t types.QueryWriteRequest
 	body, err := ioutil.ReadAll(r.Body)
+	if errors.Is(err, ErrDecompressedTooLarge) || errors.Is(err, ErrBodyTooLarge) {
+		h.Inc(metricWriteErr)
+		log.Printf("query write body over the limit after %d bytes received", reqBody.Wire())
+		utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusRequestEntityTooLarge, TLSResponse{Message: "query write body too large"})
+		return
+	}
 	if err != nil {
 		h.Inc(metricWriteErr)
 		log.Printf("error reading POST body %v", err)
@@ -565,9 +800,9 @@ func (h *HandlersTLS) QueryWriteHandler(w http.ResponseWriter, r *http.Request)
 	}
 	var nodeInvalid bool
 	// Check if provided node_key is valid and if so, update node
-	if node, err := h.Nodes.GetByKey(t.NodeKey); err == nil {
+	if node, err := h.nodeByKey(r.Context(), t.NodeKey); err == nil {
 		// Record ingested data
-		if err := h.Ingested.IngestQueryWrite(env.ID, node.ID, len(body)); err != nil {
+		if err := h.Ingested.IngestQueryWrite(env.ID, node.ID, len(body), reqBody.Wire()); err != nil {
 			h.Inc(metricWriteErr)
 			log.Printf("error with ingested query-write %v", err)
 		}
@@ -595,9 +830,17 @@ func (h *HandlersTLS) QueryWriteHandler(w http.ResponseWriter, r *http.Request)
 			log.Printf("error refreshing last query write %v", err)
 		}
 		// Process submitted results and mark query as processed
-		go h.Logs.ProcessLogQueryResult(t, env.ID, (*h.EnvsMap)[env.Name].DebugHTTP)
-	} else {
+		if err := h.Logs.EnqueueQueryResult(t, env.Name, env.ID, (*h.EnvsMap)[env.Name].DebugHTTP); err != nil {
+			h.Inc(metricWriteDrop)
+			log.Printf("error queueing query results %v", err)
+		}
+	} else if backend.IsNotFound(err) {
 		nodeInvalid = true
+	} else {
+		h.Inc(metricWriteErr)
+		log.Printf("GetByKey %v", err)
+		backendErrorResponse(w, r, err)
+		return
 	}
 	// Prepare response
 	response := types.QueryWriteResponse{NodeInvalid: nodeInvalid}
@@ -622,7 +865,7 @@ func (h *HandlersTLS) QuickEnrollHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	// Get environment
-	env, err := h.Envs.Get(envVar)
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
 	if err != nil {
 		h.Inc(metricOnelinerErr)
 		log.Printf("error getting environment - %v", err)
@@ -636,7 +879,7 @@ func (h *HandlersTLS) QuickEnrollHandler(w http.ResponseWriter, r *http.Request)
 	if !ok {
 		h.Inc(metricOnelinerErr)
 		log.Println("Script is missing")
-		utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusInternalServerError, TLSResponse{Message: "Invalid"})
+		utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusInternalServerError, TLSResponse{Message: locales.T(env.Locale, "enroll.invalid")})
 		return
 	}
 	// Retrieve SecretPath variable
@@ -644,7 +887,7 @@ func (h *HandlersTLS) QuickEnrollHandler(w http.ResponseWriter, r *http.Request)
 	if !ok {
 		h.Inc(metricOnelinerErr)
 		log.Println("Path is missing")
-		utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusInternalServerError, TLSResponse{Message: "Invalid"})
+		utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusInternalServerError, TLSResponse{Message: locales.T(env.Locale, "enroll.invalid")})
 		return
 	}
 	// Check if provided SecretPath is valid and is not expired
@@ -652,35 +895,35 @@ func (h *HandlersTLS) QuickEnrollHandler(w http.ResponseWriter, r *http.Request)
 		if !h.checkValidEnrollSecretPath(env, secretPath) {
 			h.Inc(metricOnelinerErr)
 			log.Println("Invalid secret path for enrolling")
-			utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusInternalServerError, TLSResponse{Message: "Invalid"})
+			utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusInternalServerError, TLSResponse{Message: locales.T(env.Locale, "enroll.invalid")})
 			return
 		}
 		if !h.checkExpiredEnrollSecretPath(env) {
 			h.Inc(metricOnelinerErr)
 			log.Println("Expired enrolling path")
-			utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusInternalServerError, TLSResponse{Message: "Expired"})
+			utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusInternalServerError, TLSResponse{Message: locales.T(env.Locale, "enroll.expired")})
 			return
 		}
 	} else if strings.HasPrefix(script, settings.ScriptRemove) {
 		if !h.checkValidRemoveSecretPath(env, secretPath) {
 			h.Inc(metricOnelinerErr)
 			log.Println("Invalid secret path for removing")
-			utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusInternalServerError, TLSResponse{Message: "Invalid"})
+			utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusInternalServerError, TLSResponse{Message: locales.T(env.Locale, "enroll.invalid")})
 			return
 		}
 		if !h.checkExpiredRemoveSecretPath(env) {
 			h.Inc(metricOnelinerErr)
 			log.Println("Expired removing path")
-			utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusInternalServerError, TLSResponse{Message: "Expired"})
+			utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusInternalServerError, TLSResponse{Message: locales.T(env.Locale, "enroll.expired")})
 			return
 		}
 	}
 	// Prepare response with the script
-	quickScript, err := environments.QuickAddScript("osctrl-"+env.Name, script, env)
+	quickScript, err := environments.QuickAddScript("osctrl-"+env.Name, script, h.windowsEventsScriptEnv(r.Context(), env, script))
 	if err != nil {
 		h.Inc(metricOnelinerErr)
 		log.Printf("error getting script - %v", err)
-		utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusInternalServerError, TLSResponse{Message: "Error generating script"})
+		utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusInternalServerError, TLSResponse{Message: locales.T(env.Locale, "enroll.error")})
 		return
 	}
 	// Send response
@@ -700,11 +943,11 @@ func (h *HandlersTLS) QuickRemoveHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	// Get environment
-	env, err := h.Envs.Get(envVar)
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
 	if err != nil {
 		h.Inc(metricOnelinerErr)
 		log.Printf("error getting environment - %v", err)
-		utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusInternalServerError, TLSResponse{Message: "Invalid"})
+		utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusInternalServerError, TLSResponse{Message: locales.T(env.Locale, "enroll.invalid")})
 		return
 	}
 	// Debug HTTP
@@ -714,7 +957,7 @@ func (h *HandlersTLS) QuickRemoveHandler(w http.ResponseWriter, r *http.Request)
 	if !ok {
 		h.Inc(metricOnelinerErr)
 		log.Println("Script is missing")
-		utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusInternalServerError, TLSResponse{Message: "Invalid"})
+		utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusInternalServerError, TLSResponse{Message: locales.T(env.Locale, "enroll.invalid")})
 		return
 	}
 	// Retrieve SecretPath variable
@@ -722,7 +965,7 @@ func (h *HandlersTLS) QuickRemoveHandler(w http.ResponseWriter, r *http.Request)
 	if !ok {
 		h.Inc(metricOnelinerErr)
 		log.Println("Path is missing")
-		utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusInternalServerError, TLSResponse{Message: "Invalid"})
+		utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusInternalServerError, TLSResponse{Message: locales.T(env.Locale, "enroll.invalid")})
 		return
 	}
 	// Check if provided SecretPath is valid and is not expired
@@ -730,35 +973,35 @@ func (h *HandlersTLS) QuickRemoveHandler(w http.ResponseWriter, r *http.Request)
 		if !h.checkValidEnrollSecretPath(env, secretPath) {
 			h.Inc(metricOnelinerErr)
 			log.Println("Invalid secret path for enrolling")
-			utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusInternalServerError, TLSResponse{Message: "Invalid"})
+			utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusInternalServerError, TLSResponse{Message: locales.T(env.Locale, "enroll.invalid")})
 			return
 		}
 		if !h.checkExpiredEnrollSecretPath(env) {
 			h.Inc(metricOnelinerErr)
 			log.Println("Expired enrolling path")
-			utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusInternalServerError, TLSResponse{Message: "Expired"})
+			utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusInternalServerError, TLSResponse{Message: locales.T(env.Locale, "enroll.expired")})
 			return
 		}
 	} else if strings.HasPrefix(script, settings.ScriptRemove) {
 		if !h.checkValidRemoveSecretPath(env, secretPath) {
 			h.Inc(metricOnelinerErr)
 			log.Println("Invalid secret path for removing")
-			utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusInternalServerError, TLSResponse{Message: "Invalid"})
+			utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusInternalServerError, TLSResponse{Message: locales.T(env.Locale, "enroll.invalid")})
 			return
 		}
 		if !h.checkExpiredRemoveSecretPath(env) {
 			h.Inc(metricOnelinerErr)
 			log.Println("Expired removing path")
-			utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusInternalServerError, TLSResponse{Message: "Expired"})
+			utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusInternalServerError, TLSResponse{Message: locales.T(env.Locale, "enroll.expired")})
 			return
 		}
 	}
 	// Prepare response with the script
-	quickScript, err := environments.QuickAddScript("osctrl-"+env.Name, script, env)
+	quickScript, err := environments.QuickAddScript("osctrl-"+env.Name, script, h.windowsEventsScriptEnv(r.Context(), env, script))
 	if err != nil {
 		h.Inc(metricOnelinerErr)
 		log.Printf("error getting script - %v", err)
-		utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusInternalServerError, TLSResponse{Message: "Error generating script"})
+		utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusInternalServerError, TLSResponse{Message: locales.T(env.Locale, "enroll.error")})
 		return
 	}
 	// Send response
@@ -780,12 +1023,15 @@ func (h *HandlersTLS) CarveInitHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// Get environment
-	env, err := h.Envs.Get(envVar)
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
 	if err != nil {
 		h.Inc(metricInitErr)
 		log.Printf("error getting environment %v", err)
+		backendErrorResponse(w, r, err)
 		return
 	}
+	// Carves count for the storm rate but are never throttled
+	h.stormObserve(env)
 	// Debug HTTP
 	utils.DebugHTTPDump(r, (*h.EnvsMap)[env.Name].DebugHTTP, true)
 	// Decode read POST body
@@ -804,7 +1050,7 @@ func (h *HandlersTLS) CarveInitHandler(w http.ResponseWriter, r *http.Request) {
 	initCarve := false
 	var carveSessionID string
 	// Check if provided node_key is valid and if so, update node
-	if node, err := h.Nodes.GetByKey(t.NodeKey); err == nil {
+	if node, err := h.nodeByKey(r.Context(), t.NodeKey); err == nil {
 		// Record ingested data
 		if err := h.Ingested.IngestCarveInit(env.ID, node.ID, len(body)); err != nil {
 			h.Inc(metricInitErr)
@@ -815,19 +1061,41 @@ func (h *HandlersTLS) CarveInitHandler(w http.ResponseWriter, r *http.Request) {
 			h.Inc(metricInitErr)
 			log.Printf("error recording IP address %v", err)
 		}
-		initCarve = true
-		carveSessionID = generateCarveSessionID()
-		// Process carve init
-		if err := h.ProcessCarveInit(t, carveSessionID, env.Name); err != nil {
+		if h.identityBlocked(env, node.UUID) {
+			// Nodes with identity mismatch do not carve files when enforced
+			h.Inc(metricInitErr)
+			log.Printf("not carving from node %s with identity mismatch", node.UUID)
+		} else if h.checkPaused(node.UUID) {
+			// Paused nodes do not carve files until unpaused
+			h.Inc(metricInitErr)
+			log.Printf("not carving from paused node %s", node.UUID)
+		} else if h.carvesBlocked(env) {
+			// New carves are blocked while the storage quota of the environment is exceeded
 			h.Inc(metricInitErr)
-			log.Printf("error procesing carve init %v", err)
-			initCarve = false
+			log.Printf("not carving from node %s, storage quota of %s exceeded", node.UUID, env.Name)
+		} else if h.carveRejected(env, node, t) {
+			// Carves over the limits of the environment are rejected, marked and audited
+			log.Printf("not carving from node %s, limits of %s exceeded", node.UUID, env.Name)
+		} else {
+			initCarve = true
+			carveSessionID = generateCarveSessionID()
+			// Process carve init
+			if err := h.ProcessCarveInit(t, carveSessionID, env.Name); err != nil {
+				h.Inc(metricInitErr)
+				log.Printf("error procesing carve init %v", err)
+				initCarve = false
+			}
 		}
 		// Refresh last carve request
 		if err := h.Nodes.CarveRefresh(node, ip, len(body)); err != nil {
 			h.Inc(metricInitErr)
 			log.Printf("error refreshing last carve init %v", err)
 		}
+	} else if !backend.IsNotFound(err) {
+		h.Inc(metricInitErr)
+		log.Printf("GetByKey %v", err)
+		backendErrorResponse(w, r, err)
+		return
 	}
 	// Prepare response
 	response := types.CarveInitResponse{Success: initCarve, SessionID: carveSessionID}
@@ -852,12 +1120,15 @@ func (h *HandlersTLS) CarveBlockHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	// Get environment
-	env, err := h.Envs.Get(envVar)
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
 	if err != nil {
 		h.Inc(metricBlockErr)
 		log.Printf("error getting environment %v", err)
+		backendErrorResponse(w, r, err)
 		return
 	}
+	// Carves count for the storm rate but are never throttled
+	h.stormObserve(env)
 	// Debug HTTP
 	utils.DebugHTTPDump(r, (*h.EnvsMap)[env.Name].DebugHTTP, true)
 	// Decode read POST body
@@ -875,15 +1146,21 @@ func (h *HandlersTLS) CarveBlockHandler(w http.ResponseWriter, r *http.Request)
 	}
 	blockCarve := false
 	// Check if provided session_id matches with the request_id (carve query name)
-	if carve, err := h.Carves.GetCheckCarve(t.SessionID, t.RequestID); err == nil {
+	if carve, err := h.Carves.GetCheckCarveCtx(r.Context(), t.SessionID, t.RequestID); err == nil {
+		// Queue received block, when there is no room the node has to send it again
+		if err := h.EnqueueCarveBlock(t, env.Name, carve.UUID, env.ID); err != nil {
+			h.Inc(metricBlockErr)
+			log.Printf("error queueing carve block %d of %s %v", t.BlockID, t.SessionID, err)
+			w.Header().Set("Retry-After", strconv.Itoa(int(carves.DefaultBlockRetryAfter.Seconds())))
+			utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusServiceUnavailable, types.CarveBlockResponse{Success: false})
+			return
+		}
 		// Record ingested data
 		if err := h.Ingested.IngestCarveBlock(env.ID, carve.NodeID, len(body)); err != nil {
 			h.Inc(metricInitErr)
 			log.Printf("error with ingested carve-block %v", err)
 		}
 		blockCarve = true
-		// Process received block
-		go h.ProcessCarveBlock(t, env.Name, carve.UUID, env.ID)
 		// Refresh last carve request
 		if err := h.Nodes.CarveRefreshByUUID(carve.UUID, utils.GetIP(r), len(body)); err != nil {
 			h.Inc(metricBlockErr)
@@ -913,10 +1190,11 @@ func (h *HandlersTLS) FlagsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// Get environment
-	env, err := h.Envs.Get(envVar)
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
 	if err != nil {
 		h.Inc(metricFlagsErr)
 		log.Printf("error getting environment %v", err)
+		backendErrorResponse(w, r, err)
 		return
 	}
 	// Debug HTTP for environment
@@ -936,12 +1214,24 @@ func (h *HandlersTLS) FlagsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	// Check if provided secret is valid and if so, prepare flags
 	if h.checkValidSecret(t.Secret, env) {
-		flagsStr, err := h.Envs.GenerateFlags(env, t.SecrefFile, t.CertFile)
+		// Enrolled nodes in a performance profile get its intervals
+		flagsEnv := env
+		var node nodes.OsqueryNode
+		if t.UUID != "" {
+			if node, err = h.Nodes.GetByUUIDEnv(t.UUID, env.ID); err == nil {
+				if profile, ok := h.nodeProfile(r.Context(), env, node); ok {
+					flagsEnv = env.WithProfile(profile)
+				}
+			}
+		}
+		flagsStr, err := h.Envs.GenerateFlags(flagsEnv, t.SecrefFile, t.CertFile)
 		if err != nil {
 			h.Inc(metricFlagsErr)
 			log.Printf("error generating flags %v", err)
 			return
 		}
+		// Enrolled Windows nodes get the flags of the enabled event channels
+		flagsStr = h.windowsEventsFlags(r.Context(), env, node, flagsStr)
 		response = []byte(flagsStr)
 	} else {
 		utils.HTTPResponse(w, "", http.StatusInternalServerError, []byte("uh oh..."))
@@ -969,10 +1259,11 @@ func (h *HandlersTLS) CertHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// Get environment
-	env, err := h.Envs.Get(envVar)
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
 	if err != nil {
 		h.Inc(metricCertErr)
 		log.Printf("error getting environment %v", err)
+		backendErrorResponse(w, r, err)
 		return
 	}
 	// Debug HTTP for environment
@@ -990,9 +1281,17 @@ func (h *HandlersTLS) CertHandler(w http.ResponseWriter, r *http.Request) {
 		log.Printf("error parsing POST body %v", err)
 		return
 	}
-	// Check if provided secret is valid and if so, prepare flags
+	// Check if provided secret is valid and if so, prepare certificate
 	if h.checkValidSecret(t.Secret, env) {
-		response = []byte(env.Certificate)
+		cert, err := h.Envs.CurrentCertificate(env)
+		if err != nil {
+			h.Inc(metricCertErr)
+			log.Printf("error getting certificate %v", err)
+			return
+		}
+		h.recordCertificate(env, t.UUID, cert.Version)
+		w.Header().Set(certVersionHeader, strconv.Itoa(cert.Version))
+		response = []byte(cert.Certificate)
 	} else {
 		utils.HTTPResponse(w, "", http.StatusInternalServerError, []byte("uh oh..."))
 		return
@@ -1006,6 +1305,16 @@ func (h *HandlersTLS) CertHandler(w http.ResponseWriter, r *http.Request) {
 	h.Inc(metricCertOk)
 }
 
+// Helper to keep the certificate version retrieved by a node, if the node identified itself
+func (h *HandlersTLS) recordCertificate(env environments.TLSEnvironment, uuid string, version int) {
+	if uuid == "" {
+		return
+	}
+	if err := h.Envs.RecordCertificateFetch(env.ID, uuid, version); err != nil {
+		log.Printf("error recording certificate version %v", err)
+	}
+}
+
 // VerifyHandler - Function to verify status of enrolled osquery nodes, from osctrld
 func (h *HandlersTLS) VerifyHandler(w http.ResponseWriter, r *http.Request) {
 	h.Inc(metricVerifyReq)
@@ -1019,10 +1328,11 @@ func (h *HandlersTLS) VerifyHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// Get environment
-	env, err := h.Envs.Get(envVar)
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
 	if err != nil {
 		h.Inc(metricVerifyErr)
 		log.Printf("error getting environment %v", err)
+		backendErrorResponse(w, r, err)
 		return
 	}
 	// Debug HTTP for environment
@@ -1048,10 +1358,22 @@ func (h *HandlersTLS) VerifyHandler(w http.ResponseWriter, r *http.Request) {
 			log.Printf("error generating flags %v", err)
 			return
 		}
+		cert, err := h.Envs.CurrentCertificate(env)
+		if err != nil {
+			h.Inc(metricVerifyErr)
+			log.Printf("error getting certificate %v", err)
+			return
+		}
+		h.recordCertificate(env, t.UUID, cert.Version)
 		response = types.VerifyResponse{
-			Certificate:    env.Certificate,
-			Flags:          flagsStr,
-			OsqueryVersion: defOsqueryVersion,
+			Certificate:        cert.Certificate,
+			CertificateVersion: cert.Version,
+			Flags:              flagsStr,
+			OsqueryVersion:     defOsqueryVersion,
+		}
+		// Decommissioned nodes are told to run the remove script
+		if t.UUID != "" && h.checkDecommissioned(t.UUID) {
+			response.Action = types.VerifyActionRemove
 		}
 	} else {
 		utils.HTTPResponse(w, "", http.StatusInternalServerError, []byte("uh oh..."))
@@ -1079,10 +1401,11 @@ func (h *HandlersTLS) ScriptHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// Get environment
-	env, err := h.Envs.Get(envVar)
+	env, err := h.Envs.GetCtx(r.Context(), envVar)
 	if err != nil {
 		h.Inc(metricScriptErr)
 		log.Printf("error getting environment %v", err)
+		backendErrorResponse(w, r, err)
 		return
 	}
 	// Retrieve and check action
@@ -1131,7 +1454,7 @@ func (h *HandlersTLS) ScriptHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	// Check if provided secret is valid and if so, prepare flags
 	if h.checkValidSecret(t.Secret, env) {
-		script, err := environments.QuickAddScript("osctrl-"+env.Name, actionVar, env)
+		script, err := environments.QuickAddScript("osctrl-"+env.Name, actionVar, h.windowsEventsScriptEnv(r.Context(), env, actionVar))
 		if err != nil {
 			h.Inc(metricScriptErr)
 			log.Printf("error preparing script - %v", err)
@@ -1,11 +1,19 @@
 package handlers
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/environments"
 	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
 )
 
 func TestRootHandler(t *testing.T) {
@@ -37,3 +45,29 @@ func TestErrorHandler(t *testing.T) {
 	assert.Equal(t, http.StatusInternalServerError, rr.Code)
 	assert.Equal(t, "uh oh...", rr.Body.String())
 }
+
+func TestConfigHandlerCancelled(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db, got error: %v", err)
+	}
+	defer mockDB.Close()
+	_postgres, err := gorm.Open(postgres.New(postgres.Config{Conn: mockDB}), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("unable to create new postgres database: %v", err)
+	}
+	// Slow query, as if the database was under pressure
+	mock.ExpectQuery(`SELECT \* FROM "tls_environments"`).WillDelayFor(5 * time.Second).WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "dev"))
+	h := CreateHandlersTLS(WithEnvs(&environments.Environment{DB: _postgres}))
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, "POST", "/dev/config", strings.NewReader("{}"))
+	req = mux.SetURLVars(req, map[string]string{"environment": "dev"})
+	rr := httptest.NewRecorder()
+	// Client goes away while the query is running
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	http.HandlerFunc(h.ConfigHandler).ServeHTTP(rr, req)
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+	assert.Equal(t, "", rr.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/jmpsec/osctrl/responses"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/types"
+)
+
+const (
+	// How often the identity of each node is checked with the decorations of its logs
+	identityCheckInterval = 5 * time.Minute
+	// Maximum number of nodes with the time of their last identity check, expired checks are removed when it is full
+	maxIdentityChecks = 65536
+)
+
+// identityChecks to keep when the identity of each node was checked, so logs are not parsed twice for it
+type identityChecks struct {
+	mux     sync.Mutex
+	checked map[string]time.Time
+}
+
+// Helper to get the identity policy of an environment, off if not set
+func identityPolicy(env environments.TLSEnvironment) string {
+	if env.IdentityPolicy == "" {
+		return nodes.IdentityPolicyOff
+	}
+	return env.IdentityPolicy
+}
+
+// Helper to get the similarity below which identities are mismatched, default if not set or invalid
+func (h *HandlersTLS) identityThreshold() int {
+	if h.SettingsMap != nil {
+		if s, ok := (*h.SettingsMap)[settings.IdentityThreshold]; ok && s.Integer > 0 && s.Integer <= 100 {
+			return int(s.Integer)
+		}
+	}
+	return nodes.DefaultIdentityThreshold
+}
+
+// Helper to get the webhook to notify identity mismatches, empty if not set
+func (h *HandlersTLS) identityWebhook() string {
+	if h.SettingsMap != nil {
+		if s, ok := (*h.SettingsMap)[settings.IdentityWebhook]; ok {
+			return s.String
+		}
+	}
+	return ""
+}
+
+// Helper to bind an enrolled node to the fingerprint of its enrollment, in environments with identity binding
+func (h *HandlersTLS) bindIdentity(env environments.TLSEnvironment, uuid string) {
+	if identityPolicy(env) == nodes.IdentityPolicyOff {
+		return
+	}
+	node, err := h.Nodes.GetByUUIDEnv(uuid, env.ID)
+	if err != nil {
+		log.Printf("error getting enrolled node %v", err)
+		return
+	}
+	if _, err := h.Nodes.BindIdentity(node, nodes.FingerprintFromEnrollment(node.RawEnrollment)); err != nil {
+		log.Printf("error binding identity of node %s %v", uuid, err)
+	}
+}
+
+// Helper to check if the identity of a node must be checked again, marking it as checked
+func (h *HandlersTLS) identityDue(uuid string) bool {
+	h.identities.mux.Lock()
+	defer h.identities.mux.Unlock()
+	if h.identities.checked == nil {
+		h.identities.checked = make(map[string]time.Time)
+	}
+	now := time.Now()
+	if last, ok := h.identities.checked[uuid]; ok && now.Sub(last) < identityCheckInterval {
+		return false
+	}
+	if len(h.identities.checked) >= maxIdentityChecks {
+		for u, last := range h.identities.checked {
+			if now.Sub(last) >= identityCheckInterval {
+				delete(h.identities.checked, u)
+			}
+		}
+		// All checks are recent, the cache is emptied
+		if len(h.identities.checked) >= maxIdentityChecks {
+			h.identities.checked = make(map[string]time.Time)
+		}
+	}
+	h.identities.checked[uuid] = now
+	return true
+}
+
+// Helper to get the fingerprint reported in the decorations of logs, the last one with a hardware UUID
+func identityFromLogs(data json.RawMessage) (nodes.IdentityFingerprint, bool) {
+	var logs []types.LogGenericData
+	if err := json.Unmarshal(data, &logs); err != nil {
+		return nodes.IdentityFingerprint{}, false
+	}
+	for i := len(logs) - 1; i >= 0; i-- {
+		d := logs[i].Decorations
+		if strings.TrimSpace(d.HardwareUUID) != "" {
+			return nodes.NewIdentityFingerprint(d.HardwareUUID, d.OSPlatform, d.CPUBrand, d.MACAddresses), true
+		}
+	}
+	return nodes.IdentityFingerprint{}, false
+}
+
+// Helper to check the identity of a node with the decorations of its logs, in environments with identity binding.
+// The node_key is what identifies the node, so a different host using a stolen node_key reports a conflicting identity.
+func (h *HandlersTLS) checkIdentity(env environments.TLSEnvironment, node nodes.OsqueryNode, data json.RawMessage) {
+	current, ok := identityFromLogs(data)
+	if !ok {
+		return
+	}
+	identity, flagged, err := h.Nodes.CheckIdentity(node, current, h.identityThreshold())
+	if err != nil {
+		log.Printf("error checking identity of node %s %v", node.UUID, err)
+		return
+	}
+	if flagged {
+		log.Printf("identity mismatch for node %s (%s) in %s, similarity %d%% conflict %t", node.UUID, node.Hostname, env.Name, identity.Similarity, identity.Conflict)
+		// Muted mismatches are still flagged, but not notified
+		if h.Responses != nil {
+			if _, muted := h.Responses.Muted(env.ID, node.UUID, responses.MuteIdentity, ""); muted {
+				return
+			}
+		}
+		nodes.NotifyIdentityMismatch(h.identityWebhook(), env.Name, node, identity)
+	}
+}
+
+// Helper to check if a node must not get queries or carves, because of an identity mismatch in an enforced environment
+func (h *HandlersTLS) identityBlocked(env environments.TLSEnvironment, uuid string) bool {
+	if identityPolicy(env) != nodes.IdentityPolicyEnforce {
+		return false
+	}
+	mismatch, err := h.Nodes.IsIdentityMismatch(uuid)
+	if err != nil {
+		log.Printf("error checking identity mismatch %v", err)
+		return false
+	}
+	return mismatch
+}
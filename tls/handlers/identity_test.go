@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdentityDue(t *testing.T) {
+	h := CreateHandlersTLS()
+	assert.True(t, h.identityDue("UUID1"))
+	assert.False(t, h.identityDue("UUID1"))
+	// Checks are kept for a bounded number of nodes, expired ones are removed first
+	for i := 0; i < maxIdentityChecks-1; i++ {
+		h.identities.checked[fmt.Sprintf("NODE%d", i)] = time.Now().Add(-identityCheckInterval)
+	}
+	assert.True(t, h.identityDue("UUID2"))
+	assert.Len(t, h.identities.checked, 2)
+	assert.False(t, h.identityDue("UUID1"))
+	// Recent checks are dropped only when the cache is full of them
+	for i := 0; i < maxIdentityChecks; i++ {
+		h.identities.checked[fmt.Sprintf("NODE%d", i)] = time.Now()
+	}
+	assert.True(t, h.identityDue("UUID3"))
+	assert.Len(t, h.identities.checked, 1)
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"log"
+	"sync"
+
+	"github.com/jmpsec/osctrl/backend"
+	"github.com/jmpsec/osctrl/environments"
+)
+
+// Maximum number of nodes with their last lineage point kept, the cache is emptied when it is full
+const maxLineageNodes = 65536
+
+// lineageCache to keep the last point of the configuration lineage of nodes, so it is not read for every request
+type lineageCache struct {
+	mux  sync.Mutex
+	last map[string]string
+}
+
+// Helper to remember the last point of the lineage of a node
+func (h *HandlersTLS) rememberLineage(uuid, key string) {
+	h.lineage.mux.Lock()
+	defer h.lineage.mux.Unlock()
+	if h.lineage.last == nil || len(h.lineage.last) >= maxLineageNodes {
+		h.lineage.last = make(map[string]string)
+	}
+	h.lineage.last[uuid] = key
+}
+
+// Helper to record a point in the configuration lineage of a node when its inputs changed since the last one
+func (h *HandlersTLS) recordLineage(env environments.TLSEnvironment, uuid string, inputs environments.NodeInputs) {
+	point, keep := environments.NodeLineage(env, uuid, inputs)
+	key := point.Key()
+	h.lineage.mux.Lock()
+	last, ok := h.lineage.last[point.UUID]
+	h.lineage.mux.Unlock()
+	if !ok {
+		prev, err := h.Envs.LastLineage(point.UUID)
+		if err != nil && !backend.IsNotFound(err) {
+			log.Printf("error getting configuration lineage %v", err)
+			return
+		}
+		if err == nil {
+			last, ok = prev.Key(), true
+		}
+	}
+	if ok && last == key {
+		h.rememberLineage(point.UUID, key)
+		return
+	}
+	if err := h.Envs.RecordLineage(point, keep); err != nil {
+		log.Printf("error recording configuration lineage %v", err)
+		return
+	}
+	h.rememberLineage(point.UUID, key)
+}
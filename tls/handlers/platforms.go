@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"log"
+
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/jmpsec/osctrl/settings"
+)
+
+// Helper to get the policy for enrolls of platforms not allowed, reject if not set or invalid
+func (h *HandlersTLS) platformPolicy() string {
+	if h.SettingsMap != nil {
+		if s, ok := (*h.SettingsMap)[settings.PlatformPolicy]; ok && environments.IsPlatformPolicy(s.String) {
+			return s.String
+		}
+	}
+	return environments.DefaultPlatformPolicy
+}
+
+// Helper to record an enroll of a node with a platform not allowed in the environment
+func (h *HandlersTLS) recordRejection(node nodes.OsqueryNode, env environments.TLSEnvironment, state string) {
+	r := nodes.EnrollRejection{
+		UUID:            node.UUID,
+		EnvironmentID:   env.ID,
+		Hostname:        node.Hostname,
+		Platform:        node.Platform,
+		PlatformVersion: node.PlatformVersion,
+		IPAddress:       node.IPAddress,
+		Allowed:         env.AllowedPlatforms,
+		State:           state,
+	}
+	if err := h.Nodes.RecordRejection(r); err != nil {
+		log.Printf("error recording enroll rejection %v", err)
+	}
+}
+
+// Helper to check if a node by UUID is in quarantine and must not get schedule or queries
+func (h *HandlersTLS) checkQuarantined(uuid string) bool {
+	quarantined, err := h.Nodes.IsQuarantined(uuid)
+	if err != nil {
+		log.Printf("error checking quarantined node %v", err)
+		return false
+	}
+	return quarantined
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/nodes"
+)
+
+const (
+	// How long the performance profiles of an environment are cached before reading them again from the DB
+	profilesCacheTTL = 60 * time.Second
+	// Maximum number of rendered configurations kept, the cache is emptied when it is full
+	maxRenderedConfigs = 1024
+)
+
+// profilesCache to keep performance profiles and the configurations rendered with them in memory
+type profilesCache struct {
+	mux     sync.Mutex
+	envs    map[uint]cachedProfiles
+	configs map[string][]byte
+}
+
+// cachedProfiles to keep the performance profiles of one environment until they expire
+type cachedProfiles struct {
+	profiles []environments.PerformanceProfile
+	expires  time.Time
+}
+
+// Helper to get the performance profiles of an environment, from the cache if they did not expire
+func (h *HandlersTLS) envProfiles(ctx context.Context, env environments.TLSEnvironment) []environments.PerformanceProfile {
+	now := time.Now()
+	h.profiles.mux.Lock()
+	cached, ok := h.profiles.envs[env.ID]
+	h.profiles.mux.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.profiles
+	}
+	profiles, err := h.Envs.ProfilesCtx(ctx, env.ID)
+	if err != nil {
+		// Nodes fall back to the environment defaults
+		log.Printf("error getting performance profiles %v", err)
+		return cached.profiles
+	}
+	h.profiles.mux.Lock()
+	if h.profiles.envs == nil {
+		h.profiles.envs = make(map[uint]cachedProfiles)
+	}
+	h.profiles.envs[env.ID] = cachedProfiles{profiles: profiles, expires: now.Add(profilesCacheTTL)}
+	h.profiles.mux.Unlock()
+	return profiles
+}
+
+// Helper to get the performance profile of a node, returns false if the node has no profile
+func (h *HandlersTLS) nodeProfile(ctx context.Context, env environments.TLSEnvironment, node nodes.OsqueryNode) (environments.PerformanceProfile, bool) {
+	profiles := h.envProfiles(ctx, env)
+	if len(profiles) == 0 {
+		return environments.PerformanceProfile{}, false
+	}
+	pNode := environments.ProfileNode{Platform: node.Platform}
+	for _, p := range profiles {
+		if p.HardwareModel != "" {
+			pNode.HardwareModel = nodes.HardwareModel(node)
+			break
+		}
+	}
+	if h.Tags != nil && environments.ProfilesUseTags(profiles) {
+		tags, err := h.Tags.GetTagNames(node)
+		if err != nil {
+			log.Printf("error getting tags for profiles %v", err)
+		}
+		pNode.Tags = tags
+	}
+	return environments.MatchProfile(profiles, pNode)
+}
+
+// Helper to get the configuration of an environment rendered with a profile. Renders are cached by
+// environment and profile, including when they were updated, so all the nodes of a profile share them.
+func (h *HandlersTLS) profileConfig(env environments.TLSEnvironment, profile environments.PerformanceProfile) ([]byte, error) {
+	key := fmt.Sprintf("%d/%d/%d/%d", env.ID, env.UpdatedAt.UnixNano(), profile.ID, profile.UpdatedAt.UnixNano())
+	h.profiles.mux.Lock()
+	conf, ok := h.profiles.configs[key]
+	h.profiles.mux.Unlock()
+	if ok {
+		return conf, nil
+	}
+	conf, err := environments.ApplyProfile([]byte(env.Configuration), profile)
+	if err != nil {
+		return nil, err
+	}
+	h.profiles.mux.Lock()
+	if h.profiles.configs == nil || len(h.profiles.configs) >= maxRenderedConfigs {
+		h.profiles.configs = make(map[string][]byte)
+	}
+	h.profiles.configs[key] = conf
+	h.profiles.mux.Unlock()
+	return conf, nil
+}
+
+// InvalidateEnvironment to discard the performance profiles, Windows event channels and denylist cached for an environment
+// by UUID, or for all environments if the UUID is empty or unknown. Rendered configurations do not need it, they
+// are cached by when the environment and the profile were updated.
+func (h *HandlersTLS) InvalidateEnvironment(uuid string) {
+	var envID uint
+	if h.EnvsMap != nil && uuid != "" {
+		envID = (*h.EnvsMap)[uuid].ID
+	}
+	h.profiles.mux.Lock()
+	if envID == 0 {
+		h.profiles.envs = nil
+	} else {
+		delete(h.profiles.envs, envID)
+	}
+	h.profiles.mux.Unlock()
+	h.winEvents.mux.Lock()
+	if envID == 0 {
+		h.winEvents.envs = nil
+	} else {
+		delete(h.winEvents.envs, envID)
+	}
+	h.winEvents.mux.Unlock()
+	h.denylist.mux.Lock()
+	if envID == 0 {
+		h.denylist.envs = nil
+	} else {
+		delete(h.denylist.envs, envID)
+	}
+	h.denylist.mux.Unlock()
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestNodeProfileCached(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db, got error: %v", err)
+	}
+	defer mockDB.Close()
+	_postgres, err := gorm.Open(postgres.New(postgres.Config{Conn: mockDB}), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("unable to create new postgres database: %v", err)
+	}
+	// Profiles are read once for all the nodes of the environment
+	mock.ExpectQuery(`SELECT \* FROM "performance_profiles"`).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "environment_id", "name", "platform", "hardware_model", "config_interval"}).
+			AddRow(1, 1, "laptop", "darwin", "MacBook", 3600))
+	env := environments.TLSEnvironment{Model: gorm.Model{ID: 1}, Name: "dev", ConfigInterval: 300}
+	h := CreateHandlersTLS(WithEnvs(&environments.Environment{DB: _postgres}))
+	laptop := nodes.OsqueryNode{Platform: "darwin", RawEnrollment: `{"host_details": {"system_info": {"hardware_model": "MacBookAir10,1"}}}`}
+	p, ok := h.nodeProfile(context.Background(), env, laptop)
+	assert.True(t, ok)
+	assert.Equal(t, "laptop", p.Name)
+	_, ok = h.nodeProfile(context.Background(), env, nodes.OsqueryNode{Platform: "darwin"})
+	assert.False(t, ok)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestProfileConfigShared(t *testing.T) {
+	h := CreateHandlersTLS()
+	env := environments.TLSEnvironment{Model: gorm.Model{ID: 1, UpdatedAt: time.Now()}, Configuration: `{"options": {"config_refresh": 300}}`}
+	profile := environments.PerformanceProfile{Model: gorm.Model{ID: 2}, ConfigInterval: 3600}
+	conf, err := h.profileConfig(env, profile)
+	assert.NoError(t, err)
+	assert.Contains(t, string(conf), `"config_refresh": 3600`)
+	assert.Len(t, h.profiles.configs, 1)
+	// Members of the same profile share the render
+	_, err = h.profileConfig(env, profile)
+	assert.NoError(t, err)
+	assert.Len(t, h.profiles.configs, 1)
+	// Changes to the environment render it again
+	env.UpdatedAt = env.UpdatedAt.Add(time.Second)
+	_, err = h.profileConfig(env, profile)
+	assert.NoError(t, err)
+	assert.Len(t, h.profiles.configs, 2)
+}
+
+func TestInvalidateEnvironment(t *testing.T) {
+	envsmap := environments.MapEnvironments{
+		"dev":      {Model: gorm.Model{ID: 1}, Name: "dev", UUID: "dev-uuid"},
+		"dev-uuid": {Model: gorm.Model{ID: 1}, Name: "dev", UUID: "dev-uuid"},
+	}
+	h := CreateHandlersTLS(WithEnvsMap(&envsmap))
+	expires := time.Now().Add(time.Hour)
+	h.profiles.envs = map[uint]cachedProfiles{1: {expires: expires}, 2: {expires: expires}}
+	h.winEvents.envs = map[uint]cachedWindowsEvents{1: {expires: expires}, 2: {expires: expires}}
+	// Only the environment that changed is read again
+	h.InvalidateEnvironment("dev-uuid")
+	assert.Len(t, h.profiles.envs, 1)
+	assert.Len(t, h.winEvents.envs, 1)
+	assert.Contains(t, h.profiles.envs, uint(2))
+	// Resyncs do not know which environment changed
+	h.InvalidateEnvironment("")
+	assert.Len(t, h.profiles.envs, 0)
+	assert.Len(t, h.winEvents.envs, 0)
+}
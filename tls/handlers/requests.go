@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/metrics"
+)
+
+// Writer to keep the status code of a response
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Helper to get the environment of a request for statistics. Only existing environments are used,
+// so requests with random environments can not fill the statistics.
+func (h *HandlersTLS) statsEnvironment(r *http.Request) string {
+	envVar, ok := mux.Vars(r)["environment"]
+	if !ok {
+		return ""
+	}
+	if h.EnvsMap != nil {
+		if env, ok := (*h.EnvsMap)[envVar]; ok {
+			return env.Name
+		}
+	}
+	return metrics.RequestUnknown
+}
+
+// Helper to get the route of a request for statistics. Osquery endpoints are counted by endpoint,
+// because each environment can serve them in its own paths.
+func (h *HandlersTLS) statsRoute(r *http.Request, tpl string) string {
+	if !strings.HasSuffix(tpl, "/{path}") || h.EnvsMap == nil {
+		return tpl
+	}
+	vars := mux.Vars(r)
+	if env, ok := (*h.EnvsMap)[vars["environment"]]; ok {
+		if endpoint, ok := env.Paths().Endpoint(vars["path"]); ok {
+			return strings.TrimSuffix(tpl, "{path}") + endpoint
+		}
+	}
+	return tpl
+}
+
+// RequestStatsMiddleware to count the requests of each route and environment with their latency
+func (h *HandlersTLS) RequestStatsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.Requests == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+		// Routes are the path templates, so paths with random values are not counted apart
+		route := metrics.RequestUnknown
+		if current := mux.CurrentRoute(r); current != nil {
+			if tpl, err := current.GetPathTemplate(); err == nil {
+				route = h.statsRoute(r, tpl)
+			}
+		}
+		h.Requests.Observe(route, h.statsEnvironment(r), sw.status, time.Since(start))
+	})
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestStatsMiddleware(t *testing.T) {
+	envsmap := environments.MapEnvironments{"dev": {Name: "dev"}, "1234-uuid": {Name: "dev"}}
+	requests := metrics.NewRequestStats()
+	h := CreateHandlersTLS(WithEnvsMap(&envsmap), WithRequestStats(requests))
+	router := mux.NewRouter()
+	router.Use(h.RequestStatsMiddleware)
+	router.HandleFunc("/{environment}/config", func(w http.ResponseWriter, r *http.Request) {
+		if mux.Vars(r)["environment"] == "broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}).Methods("POST")
+	for _, env := range []string{"dev", "1234-uuid", "random", "broken"} {
+		req, _ := http.NewRequest("POST", "/"+env+"/config", nil)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	rows := requests.Snapshot("tls").Windows[metrics.RequestWindow5m]
+	assert.Equal(t, 2, len(rows))
+	// Environments by name and UUID are counted together
+	assert.Equal(t, "dev", rows[0].Environment)
+	assert.Equal(t, "/{environment}/config", rows[0].Route)
+	assert.Equal(t, uint64(2), rows[0].Requests)
+	// Environments that do not exist are counted as unknown
+	assert.Equal(t, metrics.RequestUnknown, rows[1].Environment)
+	assert.Equal(t, uint64(2), rows[1].Requests)
+	assert.Equal(t, uint64(1), rows[1].Errors)
+}
+
+func TestRequestStatsEndpoints(t *testing.T) {
+	envsmap := environments.MapEnvironments{"dev": {Name: "dev", ConfigPath: "dev-config"}, "prod": {Name: "prod"}}
+	requests := metrics.NewRequestStats()
+	h := CreateHandlersTLS(WithEnvsMap(&envsmap), WithRequestStats(requests))
+	router := mux.NewRouter()
+	router.Use(h.RequestStatsMiddleware)
+	router.HandleFunc("/{environment}/{path}", func(w http.ResponseWriter, r *http.Request) {}).Methods("POST")
+	for _, path := range []string{"/dev/dev-config", "/prod/config", "/prod/random"} {
+		req, _ := http.NewRequest("POST", path, nil)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	routes := make(map[string]uint64)
+	for _, row := range requests.Snapshot("tls").Windows[metrics.RequestWindow5m] {
+		routes[row.Environment+" "+row.Route] += row.Requests
+	}
+	// Osquery endpoints are counted by endpoint, whatever the path of the environment
+	assert.Equal(t, uint64(1), routes["dev /{environment}/config"])
+	assert.Equal(t, uint64(1), routes["prod /{environment}/config"])
+	assert.Equal(t, uint64(1), routes["prod /{environment}/{path}"])
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"hash/fnv"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/utils"
+)
+
+const (
+	metricStormBackoff  = "storm-backoff"
+	metricStormThrottle = "storm-throttle"
+)
+
+// Rates of requests are counted in one second buckets over one minute
+const stormWindow = 60
+
+// StormStats to expose the storm protection state of one environment
+type StormStats struct {
+	Active bool
+	Rate   int
+	Since  time.Time
+}
+
+// Counter of requests for one environment
+type stormCounter struct {
+	seconds  [stormWindow]int64
+	buckets  [stormWindow]int
+	active   bool
+	since    time.Time
+	lastOver time.Time
+}
+
+// StormGuard to track the rate of requests per environment and detect check-in storms. A storm starts when
+// the requests per minute go over the threshold of the environment and ends when the rate stays under it
+// for the cooldown. Environments without threshold are never in a storm.
+type StormGuard struct {
+	mu       sync.Mutex
+	counters map[string]*stormCounter
+	// Now is the clock of the guard, replaced in tests
+	Now func() time.Time
+	// OnChange is called outside the lock every time a storm starts or ends
+	OnChange func(env environments.TLSEnvironment, state environments.StormState)
+}
+
+// NewStormGuard to initialize the storm guard
+func NewStormGuard() *StormGuard {
+	return &StormGuard{
+		counters: make(map[string]*stormCounter),
+		Now:      time.Now,
+	}
+}
+
+// Helper to get the requests of the last minute
+func (c *stormCounter) rate(now int64) int {
+	total := 0
+	for i := 0; i < stormWindow; i++ {
+		if now-c.seconds[i] < stormWindow {
+			total += c.buckets[i]
+		}
+	}
+	return total
+}
+
+// Observe to count one request for an environment, returning if the environment is in a storm
+func (s *StormGuard) Observe(env environments.TLSEnvironment, cooldown time.Duration) bool {
+	return s.evaluate(env, cooldown, true)
+}
+
+// Check to re-evaluate an environment without counting a request, so storms end even without traffic
+func (s *StormGuard) Check(env environments.TLSEnvironment, cooldown time.Duration) bool {
+	return s.evaluate(env, cooldown, false)
+}
+
+// Helper to count and evaluate the storm state of an environment
+func (s *StormGuard) evaluate(env environments.TLSEnvironment, cooldown time.Duration, count bool) bool {
+	now := s.Now()
+	sec := now.Unix()
+	s.mu.Lock()
+	c, ok := s.counters[env.Name]
+	if !ok {
+		if env.StormThreshold <= 0 {
+			s.mu.Unlock()
+			return false
+		}
+		c = &stormCounter{}
+		s.counters[env.Name] = c
+	}
+	if count {
+		i := sec % stormWindow
+		if c.seconds[i] != sec {
+			c.seconds[i] = sec
+			c.buckets[i] = 0
+		}
+		c.buckets[i]++
+	}
+	rate := c.rate(sec)
+	changed := false
+	switch {
+	case env.StormThreshold <= 0:
+		// Protection disabled while in a storm ends it right away
+		changed = c.active
+		c.active = false
+	case rate > env.StormThreshold:
+		c.lastOver = now
+		if !c.active {
+			c.active = true
+			c.since = now
+			changed = true
+		}
+	case c.active && now.Sub(c.lastOver) >= cooldown:
+		c.active = false
+		changed = true
+	}
+	state := environments.StormState{EnvironmentID: env.ID, Active: c.active, Rate: rate, Since: c.since}
+	s.mu.Unlock()
+	if changed && s.OnChange != nil {
+		s.OnChange(env, state)
+	}
+	return state.Active
+}
+
+// Stats to get the storm protection state of all the observed environments by name
+func (s *StormGuard) Stats() map[string]StormStats {
+	now := s.Now().Unix()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := make(map[string]StormStats, len(s.counters))
+	for name, c := range s.counters {
+		stats[name] = StormStats{Active: c.active, Rate: c.rate(now), Since: c.since}
+	}
+	return stats
+}
+
+// InBackoff to check if a node is in the fraction of nodes that back off during a storm. Nodes are
+// picked by hashing the node key, so the same nodes are picked in every request.
+func InBackoff(nodeKey string, percent int) bool {
+	if percent <= 0 || nodeKey == "" {
+		return false
+	}
+	hasher := fnv.New32a()
+	_, _ = hasher.Write([]byte(nodeKey))
+	return int(hasher.Sum32()%100) < percent
+}
+
+// Helper to get an integer storm setting from the cached settings, with a default value
+func (h *HandlersTLS) stormSetting(name string, value int) int {
+	if h.SettingsMap != nil {
+		if s, ok := (*h.SettingsMap)[name]; ok && s.Integer > 0 {
+			return int(s.Integer)
+		}
+	}
+	return value
+}
+
+// Helper to count a request from a node, returning if the environment is in a storm
+func (h *HandlersTLS) stormObserve(env environments.TLSEnvironment) bool {
+	if h.Storm == nil {
+		return false
+	}
+	cooldown := time.Duration(h.stormSetting(settings.StormCooldown, environments.DefaultStormCooldown)) * time.Second
+	return h.Storm.Observe(env, cooldown)
+}
+
+// Helper to get the backoff of an environment if the node has to back off, nil otherwise
+func (h *HandlersTLS) stormBackoff(env environments.TLSEnvironment, storm bool, nodeKey string) *environments.StormBackoff {
+	if !storm || !InBackoff(nodeKey, h.stormSetting(settings.StormPercent, environments.DefaultStormPercent)) {
+		return nil
+	}
+	backoff := env.Backoff(h.stormSetting(settings.StormFactor, environments.DefaultStormFactor))
+	return &backoff
+}
+
+// Helper to send a 429 asking the node to retry after the backoff interval
+func (h *HandlersTLS) stormThrottle(w http.ResponseWriter, env environments.TLSEnvironment, retry int) {
+	h.Inc(metricStormThrottle)
+	if (*h.EnvsMap)[env.Name].DebugHTTP {
+		log.Printf("Storm in %s, retry after %d seconds", env.Name, retry)
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(retry))
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, http.StatusTooManyRequests, TLSResponse{Message: http.StatusText(http.StatusTooManyRequests)})
+}
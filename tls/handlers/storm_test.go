@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestStormGuardBurst(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	var changes []environments.StormState
+	guard := NewStormGuard()
+	guard.Now = func() time.Time { return now }
+	guard.OnChange = func(env environments.TLSEnvironment, state environments.StormState) {
+		changes = append(changes, state)
+	}
+	env := environments.TLSEnvironment{Model: gorm.Model{ID: 1}, Name: "dev", StormThreshold: 100}
+	cooldown := 5 * time.Minute
+	// Normal traffic does not trigger protection
+	for i := 0; i < 100; i++ {
+		assert.False(t, guard.Observe(env, cooldown))
+	}
+	// Burst of check-ins after an outage
+	assert.True(t, guard.Observe(env, cooldown))
+	assert.Len(t, changes, 1)
+	assert.True(t, changes[0].Active)
+	assert.Equal(t, uint(1), changes[0].EnvironmentID)
+	assert.Equal(t, 101, guard.Stats()["dev"].Rate)
+	// Rate drops, but the storm lasts until the cooldown
+	now = now.Add(2 * time.Minute)
+	assert.True(t, guard.Observe(env, cooldown))
+	assert.Equal(t, 1, guard.Stats()["dev"].Rate)
+	// Without traffic, the storm ends after the cooldown
+	now = now.Add(4 * time.Minute)
+	assert.False(t, guard.Check(env, cooldown))
+	assert.Len(t, changes, 2)
+	assert.False(t, changes[1].Active)
+	assert.False(t, guard.Stats()["dev"].Active)
+}
+
+func TestStormBackoffWithdrawn(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	guard := NewStormGuard()
+	guard.Now = func() time.Time { return now }
+	env := environments.TLSEnvironment{Model: gorm.Model{ID: 1}, Name: "dev", ConfigInterval: 300, QueryInterval: 60, StormThreshold: 10}
+	settingsmap := settings.MapSettings{
+		settings.StormPercent:  settings.SettingValue{Integer: 100},
+		settings.StormCooldown: settings.SettingValue{Integer: 60},
+	}
+	h := CreateHandlersTLS(WithSettingsMap(&settingsmap), WithStorm(guard))
+	// Burst over the threshold serves backoff values
+	var storm bool
+	for i := 0; i < 20; i++ {
+		storm = h.stormObserve(env)
+	}
+	backoff := h.stormBackoff(env, storm, "node-key")
+	if assert.NotNil(t, backoff) {
+		assert.Equal(t, environments.StormBackoff{Factor: environments.DefaultStormFactor, ConfigInterval: 1200, QueryInterval: 240}, *backoff)
+	}
+	// Rates back to normal withdraw them after the cooldown
+	now = now.Add(2 * time.Minute)
+	assert.Nil(t, h.stormBackoff(env, h.stormObserve(env), "node-key"))
+}
+
+func TestStormGuardDisabled(t *testing.T) {
+	guard := NewStormGuard()
+	env := environments.TLSEnvironment{Model: gorm.Model{ID: 1}, Name: "dev"}
+	for i := 0; i < 1000; i++ {
+		assert.False(t, guard.Observe(env, time.Minute))
+	}
+	assert.Empty(t, guard.Stats())
+}
+
+func TestInBackoff(t *testing.T) {
+	assert.False(t, InBackoff("node-key", 0))
+	assert.True(t, InBackoff("node-key", 100))
+	assert.False(t, InBackoff("", 100))
+	// Same nodes are picked every time, about half of them with 50 percent
+	picked := 0
+	for i := 0; i < 1000; i++ {
+		key := "node-" + strconv.Itoa(i)
+		assert.Equal(t, InBackoff(key, 50), InBackoff(key, 50))
+		if InBackoff(key, 50) {
+			picked++
+		}
+	}
+	assert.InDelta(t, 500, picked, 100)
+}
+
+func TestQueryReadHandlerStorm(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db, got error: %v", err)
+	}
+	defer mockDB.Close()
+	_postgres, err := gorm.Open(postgres.New(postgres.Config{Conn: mockDB}), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("unable to create new postgres database: %v", err)
+	}
+	mock.ExpectQuery(`SELECT \* FROM "tls_environments"`).WillReturnRows(sqlmock.NewRows([]string{"id", "name", "query_interval", "storm_threshold"}).AddRow(1, "dev", 60, 1))
+	env := environments.TLSEnvironment{Model: gorm.Model{ID: 1}, Name: "dev", QueryInterval: 60, StormThreshold: 1}
+	envsmap := environments.MapEnvironments{"dev": env}
+	// All nodes back off
+	settingsmap := settings.MapSettings{settings.StormPercent: settings.SettingValue{Integer: 100}}
+	guard := NewStormGuard()
+	h := CreateHandlersTLS(WithEnvs(&environments.Environment{DB: _postgres}), WithEnvsMap(&envsmap), WithSettingsMap(&settingsmap), WithStorm(guard))
+	// Earlier check-in, so the next one goes over the threshold
+	guard.Observe(env, time.Minute)
+	req, _ := http.NewRequest("POST", "/dev/read", strings.NewReader(`{"node_key": "node-key"}`))
+	req = mux.SetURLVars(req, map[string]string{"environment": "dev"})
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(h.QueryReadHandler).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "240", rr.Header().Get("Retry-After"))
+	// The node is not looked up while throttled
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
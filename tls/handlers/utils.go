@@ -2,18 +2,25 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha1"
 	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/jmpsec/osctrl/backend"
 	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/logging"
 	"github.com/jmpsec/osctrl/nodes"
+	"github.com/jmpsec/osctrl/settings"
 	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/utils"
 	"github.com/segmentio/ksuid"
+	"gorm.io/gorm"
 )
 
 // Helper to generate a random enough node key
@@ -34,7 +41,9 @@ func generateCarveSessionID() string {
 
 // Helper to check if the provided secret is valid for this environment
 func (h *HandlersTLS) checkValidSecret(secret string, env environments.TLSEnvironment) bool {
-	return (strings.TrimSpace(secret) == env.Secret)
+	// Secret files may include enroll metadata after the secret
+	secret, _ = environments.ParseEnrollSecret(secret)
+	return (secret == env.Secret)
 }
 
 // Helper to check if the provided SecretPath is valid for enrolling in a environment
@@ -77,6 +86,7 @@ func nodeFromEnroll(req types.EnrollRequest, env environments.TLSEnvironment, ip
 	}
 	// Avoid the error "unsupported Unicode escape sequence" due to \u0000
 	enrollRaw = bytes.Replace(enrollRaw, []byte("\\u0000"), []byte(""), -1)
+	naming := nodes.NewNamingPolicy(env.NamingLowercase, env.NamingDomains, env.NamingPreferFQDN)
 	return nodes.OsqueryNode{
 		NodeKey:         nodekey,
 		UUID:            strings.ToUpper(req.HostIdentifier),
@@ -85,6 +95,7 @@ func nodeFromEnroll(req types.EnrollRequest, env environments.TLSEnvironment, ip
 		OsqueryVersion:  req.HostDetails.EnrollOsqueryInfo.Version,
 		Hostname:        req.HostDetails.EnrollSystemInfo.Hostname,
 		Localname:       req.HostDetails.EnrollSystemInfo.LocalHostname,
+		DisplayName:     naming.DisplayName(req.HostDetails.EnrollSystemInfo.Hostname, req.HostDetails.EnrollSystemInfo.LocalHostname),
 		IPAddress:       ipaddress,
 		Username:        "unknown",
 		OsqueryUser:     "unknown",
@@ -117,3 +128,99 @@ func uniq(duplicated []string) []string {
 	}
 	return result
 }
+
+// Helper to send the response for a database error. Transient failures return 503 so osquery
+// retries later, instead of getting node_invalid and enrolling again.
+func backendErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	// Nobody is waiting for the response if the client went away
+	if r.Context().Err() != nil {
+		return
+	}
+	status := backend.HTTPStatus(err)
+	utils.HTTPResponse(w, utils.JSONApplicationUTF8, status, TLSResponse{Message: http.StatusText(status)})
+}
+
+// Helper to get a node by node_key. Nodes being decommissioned are not found, so osquery
+// gets node_invalid, and the contact is recorded to know they are still around.
+func (h *HandlersTLS) nodeByKey(ctx context.Context, nodeKey string) (nodes.OsqueryNode, error) {
+	node, err := h.Nodes.GetByKeyCtx(ctx, nodeKey)
+	if err != nil {
+		return node, err
+	}
+	if h.checkDecommissioned(node.UUID) {
+		return node, fmt.Errorf("node %s decommissioned: %w", node.UUID, gorm.ErrRecordNotFound)
+	}
+	return node, nil
+}
+
+// Helper to check if a node by UUID is being decommissioned, recording the contact if so
+func (h *HandlersTLS) checkDecommissioned(uuid string) bool {
+	decommissioned, err := h.Nodes.IsDecommissioned(uuid)
+	if err != nil {
+		log.Printf("error checking decommissioned node %v", err)
+		return false
+	}
+	if decommissioned {
+		if err := h.Nodes.RecordDecommissionContact(uuid); err != nil {
+			log.Printf("error recording decommissioned node contact %v", err)
+		}
+	}
+	return decommissioned
+}
+
+// Helper to get the limits to stream the logs of one request, from settings or defaults
+func (h *HandlersTLS) logStreamConfig() logging.StreamConfig {
+	var maxBody int64
+	var entries int
+	if h.SettingsMap != nil {
+		if s, ok := (*h.SettingsMap)[settings.LogMaxBody]; ok && s.Integer > 0 {
+			maxBody = s.Integer * 1024 * 1024
+		}
+		if s, ok := (*h.SettingsMap)[settings.LogStreamEntries]; ok && s.Integer > 0 {
+			entries = int(s.Integer)
+		}
+	}
+	return logging.NewStreamConfig(maxBody, entries, 0)
+}
+
+// Helper to check if a node by UUID is paused, paused nodes keep logging but do not get queries nor carves
+func (h *HandlersTLS) checkPaused(uuid string) bool {
+	paused, err := h.Nodes.IsPaused(uuid)
+	if err != nil {
+		log.Printf("error checking paused node %v", err)
+		return false
+	}
+	return paused
+}
+
+// Helper to materialize the pending on-demand queries of a node by UUID, after it enrolls
+func (h *HandlersTLS) materializeNode(uuid string) {
+	node, err := h.Nodes.GetByUUID(uuid)
+	if err != nil {
+		log.Printf("error getting enrolled node %v", err)
+		return
+	}
+	if _, err := h.Queries.MaterializeNode(node); err != nil {
+		log.Printf("error materializing queries for node %s %v", uuid, err)
+	}
+}
+
+// Helper to get the enroll metadata sent with the secret, validated with the fields of the environment
+func (h *HandlersTLS) enrollMetadata(ctx context.Context, secret string, env environments.TLSEnvironment) (map[string]string, error) {
+	_, metadata := environments.ParseEnrollSecret(secret)
+	fields, err := h.Envs.EnrollFieldsCtx(ctx, env.ID)
+	if err != nil {
+		return nil, fmt.Errorf("EnrollFields %v", err)
+	}
+	return environments.ValidateEnrollMetadata(fields, metadata)
+}
+
+// Helper to check if new carves are blocked in an environment, because its storage quota is exceeded
+func (h *HandlersTLS) carvesBlocked(env environments.TLSEnvironment) bool {
+	quota, err := h.Envs.GetStorageQuota(env.ID)
+	if err != nil {
+		log.Printf("error getting storage quota %v", err)
+		return false
+	}
+	return quota.Active(environments.QuotaBlockCarves)
+}
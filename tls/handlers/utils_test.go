@@ -109,3 +109,15 @@ func TestUniq(t *testing.T) {
 	bb := []string{"a", "b", "c"}
 	assert.Equal(t, bb, aa)
 }
+
+func TestNodeFromEnrollNaming(t *testing.T) {
+	req := types.EnrollRequest{HostIdentifier: "thisistheuuid"}
+	req.HostDetails.EnrollSystemInfo.Hostname = "WEB-01.Corp.Example.com"
+	req.HostDetails.EnrollSystemInfo.LocalHostname = "WEB-01"
+	env := environments.TLSEnvironment{NamingLowercase: true, NamingDomains: "corp.example.com", NamingPreferFQDN: true}
+	node := nodeFromEnroll(req, env, "", "", 0)
+	assert.Equal(t, "web-01", node.DisplayName)
+	// Reported values are kept as they are
+	assert.Equal(t, "WEB-01.Corp.Example.com", node.Hostname)
+	assert.Equal(t, "WEB-01", node.Localname)
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/nodes"
+)
+
+// How long the Windows event channels of an environment are cached before reading them again from the DB
+const windowsEventsCacheTTL = 60 * time.Second
+
+// windowsEventsCache to keep the Windows event channels of environments in memory
+type windowsEventsCache struct {
+	mux  sync.Mutex
+	envs map[uint]cachedWindowsEvents
+}
+
+// cachedWindowsEvents to keep the Windows event channels of one environment until they expire
+type cachedWindowsEvents struct {
+	channels []environments.WindowsEventChannel
+	expires  time.Time
+}
+
+// Helper to get the Windows event channels of an environment, from the cache if they did not expire
+func (h *HandlersTLS) envWindowsEvents(ctx context.Context, env environments.TLSEnvironment) []environments.WindowsEventChannel {
+	now := time.Now()
+	h.winEvents.mux.Lock()
+	cached, ok := h.winEvents.envs[env.ID]
+	h.winEvents.mux.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.channels
+	}
+	channels, err := h.Envs.WindowsEventsCtx(ctx, env.ID)
+	if err != nil {
+		// Nodes keep the channels they had
+		log.Printf("error getting windows event channels %v", err)
+		return cached.channels
+	}
+	h.winEvents.mux.Lock()
+	if h.winEvents.envs == nil {
+		h.winEvents.envs = make(map[uint]cachedWindowsEvents)
+	}
+	h.winEvents.envs[env.ID] = cachedWindowsEvents{channels: channels, expires: now.Add(windowsEventsCacheTTL)}
+	h.winEvents.mux.Unlock()
+	return channels
+}
+
+// Helper to check if a node gets the flags and queries of Windows event channels
+func isWindowsNode(node nodes.OsqueryNode) bool {
+	return strings.EqualFold(node.Platform, environments.WindowsEventsPlatform)
+}
+
+// Helper to get the Windows event channels that generate scheduled queries for a node, only Windows nodes get them
+func (h *HandlersTLS) nodeChannels(ctx context.Context, env environments.TLSEnvironment, node nodes.OsqueryNode) []environments.WindowsEventChannel {
+	if !isWindowsNode(node) {
+		return nil
+	}
+	return h.envWindowsEvents(ctx, env)
+}
+
+// Helper to add the flags of Windows event channels to the flags of Windows nodes
+func (h *HandlersTLS) windowsEventsFlags(ctx context.Context, env environments.TLSEnvironment, node nodes.OsqueryNode, flags string) string {
+	if !isWindowsNode(node) {
+		return flags
+	}
+	return environments.AppendWindowsEventsFlags(flags, h.envWindowsEvents(ctx, env))
+}
+
+// Helper to get the environment used to render a script, enroll scripts for Windows get the flags of the channels
+func (h *HandlersTLS) windowsEventsScriptEnv(ctx context.Context, env environments.TLSEnvironment, script string) environments.TLSEnvironment {
+	if script != environments.EnrollPowershell {
+		return env
+	}
+	env.Flags = environments.AppendWindowsEventsFlags(env.Flags, h.envWindowsEvents(ctx, env))
+	return env
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestWindowsEventsOnlyWindows(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db, got error: %v", err)
+	}
+	defer mockDB.Close()
+	_postgres, err := gorm.Open(postgres.New(postgres.Config{Conn: mockDB}), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("unable to create new postgres database: %v", err)
+	}
+	// Channels are read once for all the nodes of the environment
+	mock.ExpectQuery(`SELECT \* FROM "windows_event_channels"`).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "environment_id", "channel", "enabled", "interval", "max_rows"}).
+			AddRow(1, 1, "Security", true, 600, 100))
+	env := environments.TLSEnvironment{Model: gorm.Model{ID: 1}, Name: "dev", Flags: "--utc=true\n"}
+	h := CreateHandlersTLS(WithEnvs(&environments.Environment{DB: _postgres}))
+	conf := []byte(`{"schedule":{}}`)
+	windows := nodes.OsqueryNode{Platform: "windows"}
+	res, err := environments.RenderNodeConfig(conf, environments.NodeInputs{Platform: windows.Platform, Channels: h.nodeChannels(context.Background(), env, windows)})
+	assert.NoError(t, err)
+	assert.Contains(t, string(res), "windows_events_security")
+	assert.Contains(t, h.windowsEventsFlags(context.Background(), env, windows, env.Flags), "--windows_event_channels=Security")
+	// Other platforms do not get them
+	linux := nodes.OsqueryNode{Platform: "ubuntu"}
+	assert.Nil(t, h.nodeChannels(context.Background(), env, linux))
+	res, err = environments.RenderNodeConfig(conf, environments.NodeInputs{Platform: linux.Platform, Channels: h.nodeChannels(context.Background(), env, linux)})
+	assert.NoError(t, err)
+	assert.Equal(t, conf, res)
+	assert.Equal(t, env.Flags, h.windowsEventsFlags(context.Background(), env, linux, env.Flags))
+	assert.Equal(t, env.Flags, h.windowsEventsScriptEnv(context.Background(), env, environments.EnrollShell).Flags)
+	assert.Contains(t, h.windowsEventsScriptEnv(context.Background(), env, environments.EnrollPowershell).Flags, "--windows_event_channels=Security")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,1176 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/jmpsec/osctrl/backend"
+	"github.com/jmpsec/osctrl/cache"
+	"github.com/jmpsec/osctrl/carves"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/logging"
+	"github.com/jmpsec/osctrl/metrics"
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/jmpsec/osctrl/queries"
+	"github.com/jmpsec/osctrl/responses"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/tags"
+	"github.com/jmpsec/osctrl/tls/handlers"
+	"github.com/jmpsec/osctrl/types"
+	"github.com/jmpsec/osctrl/utils"
+	"github.com/jmpsec/osctrl/version"
+	"github.com/urfave/cli/v2"
+	"osctrl/service"
+
+	"github.com/gorilla/mux"
+	"github.com/spf13/viper"
+)
+
+const (
+	// Project name
+	projectName string = "osctrl"
+	// Service name
+	serviceName string = projectName + "-" + settings.ServiceTLS
+	// Service version
+	serviceVersion string = version.OsctrlVersion
+	// Service description
+	serviceDescription string = "TLS service for osctrl"
+	// Application description
+	appDescription string = serviceDescription + ", a fast and efficient osquery management"
+	// Default endpoint to handle HTTP health
+	healthPath string = "/health"
+	// Default endpoint to handle the version and build of the service
+	versionPath string = "/version"
+	// Default endpoint to handle HTTP errors
+	errorPath string = "/error"
+	// Default service configuration file
+	defConfigurationFile string = "config/" + settings.ServiceTLS + ".json"
+	// Default DB configuration file
+	defDBConfigurationFile string = "config/db.json"
+	// Default redis configuration file
+	defRedisConfigurationFile string = "config/redis.json"
+	// Default Logger configuration file
+	defLoggerConfigurationFile string = "config/logger.json"
+	// Default always DB logger configuration file
+	defAlwaysLogConfigurationFile string = "config/always.json"
+	// Default carver configuration file
+	defCarverConfigurationFile string = "config/carver.json"
+	// Default TLS certificate file
+	defTLSCertificateFile string = "config/tls.crt"
+	// Default TLS private key file
+	defTLSKeyFile string = "config/tls.key"
+	// Default refreshing interval in seconds
+	defaultRefresh int = 300
+	// Default percentage of the refreshing interval to splay refreshes of instances
+	defaultRefreshSplay int = 10
+	// Default accelerate interval in seconds
+	defaultAccelerate int = 60
+	// Default expiration of oneliners for enroll/expire
+	defaultOnelinerExpiration bool = true
+	// Default interval in seconds to send metrics for the log queue
+	defaultQueueMetrics int = 60
+	// Default time in seconds for the carve scanner to return a verdict
+	defaultCarveScanTimeout int = 300
+	// Default interval in seconds to publish the request statistics
+	defaultRequestStats int = 30
+	// Times to wait for the environments map that another instance refreshes from the DB
+	envsRefreshTries int = 4
+	// Pause between waits for the environments map that another instance refreshes from the DB
+	envsRefreshPause = 500 * time.Millisecond
+)
+
+// Global variables
+var (
+	err             error
+	tlsConfig       types.JSONConfigurationTLS
+	dbConfig        backend.JSONConfigurationDB
+	redisConfig     cache.JSONConfigurationRedis
+	db              *backend.DBManager
+	redis           *cache.RedisManager
+	settingsmgr     *settings.Settings
+	envs            *environments.Environment
+	envsmap         environments.MapEnvironments
+	envsSecrets     envSecrets
+	settingsmap     settings.MapSettings
+	nodesmgr        *nodes.NodeManager
+	queriesmgr      *queries.Queries
+	filecarves      *carves.Carves
+	responsesmgr    *responses.ResponseManager
+	tlsMetrics      *metrics.Metrics
+	ingestedMetrics *metrics.IngestedManager
+	loggerTLS       *logging.LoggerTLS
+	handlersTLS     *handlers.HandlersTLS
+	stormGuard      *handlers.StormGuard
+	requestStats    *metrics.RequestStats
+	eventBus        *cache.EventBus
+	tagsmgr         *tags.TagManager
+	carvers3        *carves.CarverS3
+	s3LogConfig     types.S3Configuration
+	s3CarverConfig  types.S3Configuration
+	app             *cli.App
+	flags           []cli.Flag
+)
+
+// Variables for flags
+var (
+	configFlag         bool
+	serviceConfigFile  string
+	redisConfigFile    string
+	dbFlag             bool
+	redisFlag          bool
+	dbConfigFile       string
+	tlsServer          bool
+	tlsCertFile        string
+	tlsKeyFile         string
+	tlsProfile         string
+	tlsMinVersion      string
+	tlsMaxVersion      string
+	tlsCiphers         string
+	tlsCurves          string
+	tlsNoTickets       bool
+	loggerFile         string
+	alwaysLog          bool
+	alwaysLogFile      string
+	alwaysFilters      logging.AlwaysFilters
+	carverConfigFile   string
+	logQueueConfig     logging.QueueConfig
+	logQueueShutdown   time.Duration
+	carveQueueConfig   carves.BlockQueueConfig
+	carveWorkersDB     int
+	carveWorkersS3     int
+	carveQueueShutdown time.Duration
+	ipHistoryMax       int
+	geoipCityFile      string
+	geoipASNFile       string
+	dbReplicas         string
+	dbPrimaryReads     string
+	instanceName       string
+)
+
+// Valid values for authentication in configuration
+var validAuth = map[string]bool{
+	settings.AuthNone: true,
+}
+
+// Valid values for logging in configuration
+var validLogging = map[string]bool{
+	settings.LoggingNone:    true,
+	settings.LoggingStdout:  true,
+	settings.LoggingFile:    true,
+	settings.LoggingDB:      true,
+	settings.LoggingGraylog: true,
+	settings.LoggingSplunk:  true,
+	settings.LoggingKafka:   true,
+	settings.LoggingKinesis: true,
+	settings.LoggingS3:      true,
+}
+
+// Valid values for carver in configuration
+var validCarver = map[string]bool{
+	settings.CarverDB:    true,
+	settings.CarverLocal: true,
+	settings.CarverS3:    true,
+}
+
+// Function to load the configuration file and assign to variables
+func loadConfiguration(file, service string) (types.JSONConfigurationTLS, error) {
+	var cfg types.JSONConfigurationTLS
+	log.Printf("Loading %s", file)
+	// Load file and read config
+	viper.SetConfigFile(file)
+	if err := viper.ReadInConfig(); err != nil {
+		return cfg, err
+	}
+	// TLS endpoint values
+	tlsRaw := viper.Sub(service)
+	if tlsRaw == nil {
+		return cfg, fmt.Errorf("JSON key %s not found in %s", service, file)
+	}
+	if err := tlsRaw.Unmarshal(&cfg); err != nil {
+		return cfg, err
+	}
+	// Check if values are valid
+	if !validAuth[cfg.Auth] {
+		return cfg, fmt.Errorf("Invalid auth method")
+	}
+	if !validLogging[cfg.Logger] {
+		return cfg, fmt.Errorf("Invalid logging method")
+	}
+	if !validCarver[cfg.Carver] {
+		return cfg, fmt.Errorf("Invalid carver method")
+	}
+	// No errors!
+	return cfg, nil
+}
+
+// Initialization code
+func init() {
+	// Initialize CLI flags
+	flags = []cli.Flag{
+		&cli.BoolFlag{
+			Name:        "config",
+			Aliases:     []string{"c"},
+			Value:       false,
+			Usage:       "Provide service configuration via JSON file",
+			EnvVars:     []string{"SERVICE_CONFIG"},
+			Destination: &configFlag,
+		},
+		&cli.StringFlag{
+			Name:        "config-file",
+			Aliases:     []string{"C"},
+			Value:       defConfigurationFile,
+			Usage:       "Load service configuration from `FILE`",
+			EnvVars:     []string{"SERVICE_CONFIG_FILE"},
+			Destination: &serviceConfigFile,
+		},
+		&cli.StringFlag{
+			Name:        "listener",
+			Aliases:     []string{"l"},
+			Value:       "0.0.0.0",
+			Usage:       "Listener for the service",
+			EnvVars:     []string{"SERVICE_LISTENER"},
+			Destination: &tlsConfig.Listener,
+		},
+		&cli.StringFlag{
+			Name:        "port",
+			Aliases:     []string{"p"},
+			Value:       "9000",
+			Usage:       "TCP port for the service",
+			EnvVars:     []string{"SERVICE_PORT"},
+			Destination: &tlsConfig.Port,
+		},
+		&cli.StringFlag{
+			Name:        "auth",
+			Aliases:     []string{"A"},
+			Value:       settings.AuthNone,
+			Usage:       "Authentication mechanism for the service",
+			EnvVars:     []string{"SERVICE_AUTH"},
+			Destination: &tlsConfig.Auth,
+		},
+		&cli.StringFlag{
+			Name:        "host",
+			Aliases:     []string{"H"},
+			Value:       "0.0.0.0",
+			Usage:       "Exposed hostname the service uses",
+			EnvVars:     []string{"SERVICE_HOST"},
+			Destination: &tlsConfig.Host,
+		},
+		&cli.StringFlag{
+			Name:        "logger",
+			Aliases:     []string{"L"},
+			Value:       settings.LoggingDB,
+			Usage:       "Logger mechanism to handle status/result logs from nodes",
+			EnvVars:     []string{"SERVICE_LOGGER"},
+			Destination: &tlsConfig.Logger,
+		},
+		&cli.BoolFlag{
+			Name:        "redis",
+			Aliases:     []string{"r"},
+			Value:       false,
+			Usage:       "Provide redis configuration via JSON file",
+			EnvVars:     []string{"REDIS_CONFIG"},
+			Destination: &redisFlag,
+		},
+		&cli.StringFlag{
+			Name:        "redis-file",
+			Aliases:     []string{"R"},
+			Value:       defRedisConfigurationFile,
+			Usage:       "Load redis configuration from `FILE`",
+			EnvVars:     []string{"REDIS_CONFIG_FILE"},
+			Destination: &redisConfigFile,
+		},
+		&cli.StringFlag{
+			Name:        "redis-connection-string",
+			Value:       "",
+			Usage:       "Redis connection string, must include schema (<redis|rediss|unix>://<user>:<pass>@<host>:<port>/<db>?<options>",
+			EnvVars:     []string{"REDIS_CONNECTION_STRING"},
+			Destination: &redisConfig.ConnectionString,
+		},
+		&cli.StringFlag{
+			Name:        "redis-host",
+			Value:       "127.0.0.1",
+			Usage:       "Redis host to be connected to",
+			EnvVars:     []string{"REDIS_HOST"},
+			Destination: &redisConfig.Host,
+		},
+		&cli.StringFlag{
+			Name:        "redis-port",
+			Value:       "6379",
+			Usage:       "Redis port to be connected to",
+			EnvVars:     []string{"REDIS_PORT"},
+			Destination: &redisConfig.Port,
+		},
+		&cli.StringFlag{
+			Name:        "redis-pass",
+			Value:       "",
+			Usage:       "Password to be used for redis",
+			EnvVars:     []string{"REDIS_PASS"},
+			Destination: &redisConfig.Password,
+		},
+		&cli.IntFlag{
+			Name:        "redis-db",
+			Value:       0,
+			Usage:       "Redis database to be selected after connecting",
+			EnvVars:     []string{"REDIS_DB"},
+			Destination: &redisConfig.DB,
+		},
+		&cli.IntFlag{
+			Name:        "redis-status-exp",
+			Value:       cache.StatusExpiration,
+			Usage:       "Redis expiration in hours for status logs",
+			EnvVars:     []string{"REDIS_STATUS_EXP"},
+			Destination: &redisConfig.StatusExpirationHours,
+		},
+		&cli.IntFlag{
+			Name:        "redis-result-exp",
+			Value:       cache.ResultExpiration,
+			Usage:       "Redis expiration in hours for result logs",
+			EnvVars:     []string{"REDIS_RESULT_EXP"},
+			Destination: &redisConfig.ResultExpirationHours,
+		},
+		&cli.IntFlag{
+			Name:        "redis-query-exp",
+			Value:       cache.QueryExpiration,
+			Usage:       "Redis expiration in hours for query logs",
+			EnvVars:     []string{"REDIS_QUERY_EXP"},
+			Destination: &redisConfig.QueryExpirationHours,
+		},
+		&cli.BoolFlag{
+			Name:        "db",
+			Aliases:     []string{"d"},
+			Value:       false,
+			Usage:       "Provide DB configuration via JSON file",
+			EnvVars:     []string{"DB_CONFIG"},
+			Destination: &dbFlag,
+		},
+		&cli.StringFlag{
+			Name:        "db-file",
+			Aliases:     []string{"D"},
+			Value:       defDBConfigurationFile,
+			Usage:       "Load DB configuration from `FILE`",
+			EnvVars:     []string{"DB_CONFIG_FILE"},
+			Destination: &dbConfigFile,
+		},
+		&cli.StringFlag{
+			Name:        "db-host",
+			Value:       "127.0.0.1",
+			Usage:       "Backend host to be connected to",
+			EnvVars:     []string{"DB_HOST"},
+			Destination: &dbConfig.Host,
+		},
+		&cli.StringFlag{
+			Name:        "db-port",
+			Value:       "5432",
+			Usage:       "Backend port to be connected to",
+			EnvVars:     []string{"DB_PORT"},
+			Destination: &dbConfig.Port,
+		},
+		&cli.StringFlag{
+			Name:        "db-name",
+			Value:       "osctrl",
+			Usage:       "Database name to be used in the backend",
+			EnvVars:     []string{"DB_NAME"},
+			Destination: &dbConfig.Name,
+		},
+		&cli.StringFlag{
+			Name:        "db-user",
+			Value:       "postgres",
+			Usage:       "Username to be used for the backend",
+			EnvVars:     []string{"DB_USER"},
+			Destination: &dbConfig.Username,
+		},
+		&cli.StringFlag{
+			Name:        "db-pass",
+			Value:       "postgres",
+			Usage:       "Password to be used for the backend",
+			EnvVars:     []string{"DB_PASS"},
+			Destination: &dbConfig.Password,
+		},
+		&cli.IntFlag{
+			Name:        "db-max-idle-conns",
+			Value:       20,
+			Usage:       "Maximum number of connections in the idle connection pool",
+			EnvVars:     []string{"DB_MAX_IDLE_CONNS"},
+			Destination: &dbConfig.MaxIdleConns,
+		},
+		&cli.IntFlag{
+			Name:        "db-max-open-conns",
+			Value:       100,
+			Usage:       "Maximum number of open connections to the database",
+			EnvVars:     []string{"DB_MAX_OPEN_CONNS"},
+			Destination: &dbConfig.MaxOpenConns,
+		},
+		&cli.IntFlag{
+			Name:        "db-conn-max-lifetime",
+			Value:       30,
+			Usage:       "Maximum amount of time a connection may be reused",
+			EnvVars:     []string{"DB_CONN_MAX_LIFETIME"},
+			Destination: &dbConfig.ConnMaxLifetime,
+		},
+		&cli.IntFlag{
+			Name:        "db-conn-max-idle-time",
+			Value:       0,
+			Usage:       "Maximum amount of time in seconds a connection may be idle, 0 to keep idle connections",
+			EnvVars:     []string{"DB_CONN_MAX_IDLE_TIME"},
+			Destination: &dbConfig.ConnMaxIdleTime,
+		},
+		&cli.IntFlag{
+			Name:        "db-instances",
+			Value:       1,
+			Usage:       "Expected number of instances of this service, to check if their connections fit in the database",
+			EnvVars:     []string{"DB_INSTANCES"},
+			Destination: &dbConfig.Instances,
+		},
+		&cli.StringFlag{
+			Name:        "db-replicas",
+			Value:       "",
+			Usage:       "Comma separated list of host:port of read replicas, using the same DB name and credentials",
+			EnvVars:     []string{"DB_REPLICAS"},
+			Destination: &dbReplicas,
+		},
+		&cli.IntFlag{
+			Name:        "db-replica-max-lag",
+			Value:       0,
+			Usage:       "Maximum replication lag in seconds before a replica stops receiving reads, 0 means no limit",
+			EnvVars:     []string{"DB_REPLICA_MAX_LAG"},
+			Destination: &dbConfig.MaxReplicaLag,
+		},
+		&cli.StringFlag{
+			Name:        "db-primary-reads",
+			Value:       "",
+			Usage:       "Comma separated list of managers (nodes, queries, environments, settings) that always read from the primary",
+			EnvVars:     []string{"DB_PRIMARY_READS"},
+			Destination: &dbPrimaryReads,
+		},
+		&cli.BoolFlag{
+			Name:        "tls",
+			Aliases:     []string{"t"},
+			Value:       false,
+			Usage:       "Enable TLS termination. It requires certificate and key",
+			EnvVars:     []string{"TLS_SERVER"},
+			Destination: &tlsServer,
+		},
+		&cli.StringFlag{
+			Name:        "cert",
+			Aliases:     []string{"T"},
+			Value:       defTLSCertificateFile,
+			Usage:       "TLS termination certificate from `FILE`",
+			EnvVars:     []string{"TLS_CERTIFICATE"},
+			Destination: &tlsCertFile,
+		},
+		&cli.StringFlag{
+			Name:        "key",
+			Aliases:     []string{"K"},
+			Value:       defTLSKeyFile,
+			Usage:       "TLS termination private key from `FILE`",
+			EnvVars:     []string{"TLS_KEY"},
+			Destination: &tlsKeyFile,
+		},
+		&cli.StringFlag{
+			Name:        "tls-profile",
+			Value:       utils.DefaultTLSProfile,
+			Usage:       "TLS termination profile (legacy, modern, intermediate or fips)",
+			EnvVars:     []string{"TLS_PROFILE"},
+			Destination: &tlsProfile,
+		},
+		&cli.StringFlag{
+			Name:        "tls-min-version",
+			Value:       "",
+			Usage:       "Override the minimum TLS version of the profile (1.0, 1.1, 1.2 or 1.3)",
+			EnvVars:     []string{"TLS_MIN_VERSION"},
+			Destination: &tlsMinVersion,
+		},
+		&cli.StringFlag{
+			Name:        "tls-max-version",
+			Value:       "",
+			Usage:       "Override the maximum TLS version of the profile (1.0, 1.1, 1.2 or 1.3)",
+			EnvVars:     []string{"TLS_MAX_VERSION"},
+			Destination: &tlsMaxVersion,
+		},
+		&cli.StringFlag{
+			Name:        "tls-ciphers",
+			Value:       "",
+			Usage:       "Override the cipher suites of the profile, as comma separated list of Go names",
+			EnvVars:     []string{"TLS_CIPHERS"},
+			Destination: &tlsCiphers,
+		},
+		&cli.StringFlag{
+			Name:        "tls-curves",
+			Value:       "",
+			Usage:       "Override the curves of the profile, as comma separated list (X25519, P256, P384, P521)",
+			EnvVars:     []string{"TLS_CURVES"},
+			Destination: &tlsCurves,
+		},
+		&cli.BoolFlag{
+			Name:        "tls-disable-session-tickets",
+			Value:       false,
+			Usage:       "Disable TLS session tickets",
+			EnvVars:     []string{"TLS_DISABLE_SESSION_TICKETS"},
+			Destination: &tlsNoTickets,
+		},
+		&cli.StringFlag{
+			Name:        "logger-file",
+			Aliases:     []string{"F"},
+			Value:       defLoggerConfigurationFile,
+			Usage:       "Logger configuration to handle status/results logs from nodes",
+			EnvVars:     []string{"LOGGER_FILE"},
+			Destination: &loggerFile,
+		},
+		&cli.BoolFlag{
+			Name:        "always-log",
+			Aliases:     []string{"a", "always"},
+			Value:       false,
+			Usage:       "Always log status and on-demand query logs from nodes in database",
+			EnvVars:     []string{"ALWAYS_LOG"},
+			Destination: &alwaysLog,
+		},
+		&cli.StringFlag{
+			Name:        "always-log-file",
+			Value:       defAlwaysLogConfigurationFile,
+			Usage:       "Load filters for the always logger from JSON file, if it exists",
+			EnvVars:     []string{"ALWAYS_LOG_FILE"},
+			Destination: &alwaysLogFile,
+		},
+		&cli.IntFlag{
+			Name:        "log-workers",
+			Value:       logging.DefaultQueueWorkers,
+			Usage:       "Number of workers to process status/result logs from nodes",
+			EnvVars:     []string{"LOG_WORKERS"},
+			Destination: &logQueueConfig.Workers,
+		},
+		&cli.IntFlag{
+			Name:        "log-queue-size",
+			Value:       logging.DefaultQueueSize,
+			Usage:       "Maximum number of pending log batches waiting to be processed",
+			EnvVars:     []string{"LOG_QUEUE_SIZE"},
+			Destination: &logQueueConfig.Size,
+		},
+		&cli.IntFlag{
+			Name:        "log-queue-env-size",
+			Value:       0,
+			Usage:       "Maximum number of pending log batches for a single environment, 0 to use the queue size",
+			EnvVars:     []string{"LOG_QUEUE_ENV_SIZE"},
+			Destination: &logQueueConfig.EnvSize,
+		},
+		&cli.BoolFlag{
+			Name:        "log-queue-block",
+			Value:       false,
+			Usage:       "Block requests when the log queue is full, instead of dropping logs",
+			EnvVars:     []string{"LOG_QUEUE_BLOCK"},
+			Destination: &logQueueConfig.Block,
+		},
+		&cli.DurationFlag{
+			Name:        "log-queue-shutdown",
+			Value:       logging.DefaultQueueShutdown,
+			Usage:       "Maximum time to process pending logs when the service is stopped",
+			EnvVars:     []string{"LOG_QUEUE_SHUTDOWN"},
+			Destination: &logQueueShutdown,
+		},
+		&cli.IntFlag{
+			Name:        "carve-queue-size",
+			Value:       carves.DefaultBlockQueueSize,
+			Usage:       "Maximum number of pending carve blocks waiting to be stored, more blocks are rejected for nodes to send them again",
+			EnvVars:     []string{"CARVE_QUEUE_SIZE"},
+			Destination: &carveQueueConfig.Size,
+		},
+		&cli.IntFlag{
+			Name:        "carve-workers-db",
+			Value:       carves.DefaultBlockWorkersDB,
+			Usage:       "Number of concurrent writes of carve blocks when the carver is db",
+			EnvVars:     []string{"CARVE_WORKERS_DB"},
+			Destination: &carveWorkersDB,
+		},
+		&cli.IntFlag{
+			Name:        "carve-workers-s3",
+			Value:       carves.DefaultBlockWorkersS3,
+			Usage:       "Number of concurrent uploads of carve blocks when the carver is s3",
+			EnvVars:     []string{"CARVE_WORKERS_S3"},
+			Destination: &carveWorkersS3,
+		},
+		&cli.IntFlag{
+			Name:        "carve-block-retries",
+			Value:       carves.DefaultBlockRetries,
+			Usage:       "Number of attempts to store one carve block",
+			EnvVars:     []string{"CARVE_BLOCK_RETRIES"},
+			Destination: &carveQueueConfig.Retries,
+		},
+		&cli.DurationFlag{
+			Name:        "carve-queue-shutdown",
+			Value:       carves.DefaultBlockShutdown,
+			Usage:       "Maximum time to store pending carve blocks when the service is stopped",
+			EnvVars:     []string{"CARVE_QUEUE_SHUTDOWN"},
+			Destination: &carveQueueShutdown,
+		},
+		&cli.Int64Flag{
+			Name:        "max-body",
+			Value:       handlers.DefaultMaxBody,
+			Usage:       "Maximum size in MB of the body of log and query write requests, bigger requests get 413",
+			EnvVars:     []string{"MAX_BODY_MB"},
+			Destination: &tlsConfig.MaxBodyMB,
+		},
+		&cli.Int64Flag{
+			Name:        "max-body-small",
+			Value:       handlers.DefaultMaxBodySmall,
+			Usage:       "Maximum size in MB of the body of enroll, config, query read, carve init and osctrld requests",
+			EnvVars:     []string{"MAX_BODY_SMALL_MB"},
+			Destination: &tlsConfig.MaxBodySmallMB,
+		},
+		&cli.Int64Flag{
+			Name:        "max-body-carve",
+			Value:       handlers.DefaultMaxBodyCarve,
+			Usage:       "Maximum size in MB of the body of carve block requests",
+			EnvVars:     []string{"MAX_BODY_CARVE_MB"},
+			Destination: &tlsConfig.MaxBodyCarveMB,
+		},
+		&cli.IntFlag{
+			Name:        "ip-history-max",
+			Value:       nodes.DefaultMaxIPHistory,
+			Usage:       "Maximum number of distinct IP addresses to keep per node",
+			EnvVars:     []string{"IP_HISTORY_MAX"},
+			Destination: &ipHistoryMax,
+		},
+		&cli.StringFlag{
+			Name:        "geoip-city-db",
+			Value:       "",
+			Usage:       "MaxMind City DB `FILE` to resolve node IP addresses to country and city",
+			EnvVars:     []string{"GEOIP_CITY_DB"},
+			Destination: &geoipCityFile,
+		},
+		&cli.StringFlag{
+			Name:        "geoip-asn-db",
+			Value:       "",
+			Usage:       "MaxMind ASN DB `FILE` to resolve node IP addresses to ASN",
+			EnvVars:     []string{"GEOIP_ASN_DB"},
+			Destination: &geoipASNFile,
+		},
+		&cli.StringFlag{
+			Name:        "instance-name",
+			Value:       "",
+			Usage:       "Name of this instance in the request statistics, hostname and port by default",
+			EnvVars:     []string{"INSTANCE_NAME"},
+			Destination: &instanceName,
+		},
+		&cli.StringFlag{
+			Name:        "carver-type",
+			Value:       settings.CarverDB,
+			Usage:       "Carver to be used to receive files extracted from nodes",
+			EnvVars:     []string{"CARVER_TYPE"},
+			Destination: &tlsConfig.Carver,
+		},
+		&cli.StringFlag{
+			Name:        "carver-file",
+			Value:       defCarverConfigurationFile,
+			Usage:       "Carver configuration file to receive files extracted from nodes",
+			EnvVars:     []string{"CARVER_FILE"},
+			Destination: &carverConfigFile,
+		},
+		&cli.StringFlag{
+			Name:        "log-s3-bucket",
+			Value:       "",
+			Usage:       "S3 bucket to be used as configuration for logging",
+			EnvVars:     []string{"LOG_S3_BUCKET"},
+			Destination: &s3LogConfig.Bucket,
+		},
+		&cli.StringFlag{
+			Name:        "log-s3-region",
+			Value:       "",
+			Usage:       "S3 region to be used as configuration for logging",
+			EnvVars:     []string{"LOG_S3_REGION"},
+			Destination: &s3LogConfig.Region,
+		},
+		&cli.StringFlag{
+			Name:        "log-s3-key-id",
+			Value:       "",
+			Usage:       "S3 access key id to be used as configuration for logging",
+			EnvVars:     []string{"LOG_S3_KEY_ID"},
+			Destination: &s3LogConfig.AccessKey,
+		},
+		&cli.StringFlag{
+			Name:        "log-s3-secret",
+			Value:       "",
+			Usage:       "S3 access key secret to be used as configuration for logging",
+			EnvVars:     []string{"LOG_S3_SECRET"},
+			Destination: &s3LogConfig.SecretAccessKey,
+		},
+		&cli.StringFlag{
+			Name:        "log-s3-key-template",
+			Value:       "",
+			Usage:       "Layout of the keys of S3 logs with variables like {environment}, {log_type}, {name}, {date} or {timestamp}",
+			EnvVars:     []string{"LOG_S3_KEY_TEMPLATE"},
+			Destination: &s3LogConfig.KeyTemplate,
+		},
+		&cli.IntFlag{
+			Name:        "log-s3-rotate-mb",
+			Value:       0,
+			Usage:       "Size in MB to write the buffered logs as one S3 object, 0 to write every request",
+			EnvVars:     []string{"LOG_S3_ROTATE_MB"},
+			Destination: &s3LogConfig.RotateMB,
+		},
+		&cli.IntFlag{
+			Name:        "log-s3-rotate-seconds",
+			Value:       0,
+			Usage:       "Age in seconds to write the buffered logs as one S3 object, 0 to write every request",
+			EnvVars:     []string{"LOG_S3_ROTATE_SECONDS"},
+			Destination: &s3LogConfig.RotateSeconds,
+		},
+		&cli.BoolFlag{
+			Name:        "log-s3-gzip",
+			Value:       false,
+			Usage:       "Compress S3 logs with gzip",
+			EnvVars:     []string{"LOG_S3_GZIP"},
+			Destination: &s3LogConfig.Gzip,
+		},
+		&cli.StringFlag{
+			Name:        "carver-s3-bucket",
+			Value:       "",
+			Usage:       "S3 bucket to be used as configuration for carves",
+			EnvVars:     []string{"CARVER_S3_BUCKET"},
+			Destination: &s3CarverConfig.Bucket,
+		},
+		&cli.StringFlag{
+			Name:        "carver-s3-region",
+			Value:       "",
+			Usage:       "S3 region to be used as configuration for carves",
+			EnvVars:     []string{"CARVER_S3_REGION"},
+			Destination: &s3CarverConfig.Region,
+		},
+		&cli.StringFlag{
+			Name:        "carve-s3-key-id",
+			Value:       "",
+			Usage:       "S3 access key id to be used as configuration for carves",
+			EnvVars:     []string{"CARVER_S3_KEY_ID"},
+			Destination: &s3CarverConfig.AccessKey,
+		},
+		&cli.StringFlag{
+			Name:        "carve-s3-secret",
+			Value:       "",
+			Usage:       "S3 access key secret to be used as configuration for carves",
+			EnvVars:     []string{"CARVER_S3_SECRET"},
+			Destination: &s3CarverConfig.SecretAccessKey,
+		},
+	}
+	// Logging format flags
+	log.SetFlags(log.Lshortfile)
+}
+
+// Go go!
+func osctrlService(ctx context.Context, shared *service.Shared) {
+	// Managers created by the service are stopped by it, shared ones by the process that created them
+	owned := shared == nil
+	if owned {
+		if shared, err = CreateShared(); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+	db = shared.DB
+	redis = shared.Redis
+	// Background jobs stop when the service shuts down
+	appCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	envs = shared.Envs
+	settingsmgr = shared.Settings
+	nodesmgr = shared.Nodes
+	nodesmgr.MaxIPHistory = ipHistoryMax
+	if geoipCityFile != "" || geoipASNFile != "" {
+		log.Println("Initialize GeoIP")
+		geoip, err := nodes.CreateGeoIP(geoipCityFile, geoipASNFile)
+		if err != nil {
+			log.Fatalf("Error loading GeoIP - %v", err)
+		}
+		nodesmgr.EnableGeoIP(geoip)
+	}
+	tagsmgr = shared.Tags
+	queriesmgr = shared.Queries
+	filecarves = shared.Carves
+	responsesmgr = shared.Responses
+	if len(db.Replicas) > 0 {
+		log.Printf("Routing reads to %d replicas", len(db.Replicas))
+		primary := make(map[string]bool)
+		for _, m := range splitValues(dbPrimaryReads) {
+			primary[m] = true
+		}
+		if !primary["nodes"] {
+			nodesmgr.Reader = db.Reader
+		}
+		if !primary["queries"] {
+			queriesmgr.Reader = db.Reader
+		}
+		if !primary["environments"] {
+			envs.Reader = db.Reader
+		}
+		if !primary["settings"] {
+			settingsmgr.Reader = db.Reader
+		}
+		stopReplicaChecks := db.StartReplicaChecks(appCtx, backend.DefaultReplicaCheck)
+		defer stopReplicaChecks()
+	}
+	log.Println("Loading service settings")
+	if err := loadingSettings(settingsmgr); err != nil {
+		log.Fatalf("Error loading settings - %s: %v", tlsConfig.Logger, err)
+	}
+	// Initialize service metrics
+	log.Println("Loading service metrics")
+	tlsMetrics, err = loadingMetrics(settingsmgr)
+	if err != nil {
+		log.Fatalf("Error loading metrics - %v", err)
+	}
+	// Initialize ingested data metrics
+	log.Println("Initialize ingested")
+	ingestedMetrics = metrics.CreateIngested(db.Conn)
+	// Initialize TLS logger
+	log.Println("Loading TLS logger")
+	// Logger only writes, so it does not need replicas
+	loggerDBConfig := dbConfig
+	loggerDBConfig.Replicas = nil
+	loggerTLS, err = logging.CreateLoggerTLS(tlsConfig.Logger, loggerFile, s3LogConfig, alwaysLog, loggerDBConfig, settingsmgr, nodesmgr, queriesmgr, redis)
+	if err != nil {
+		log.Fatalf("Error loading logger - %s: %v", tlsConfig.Logger, err)
+	}
+	if alwaysLog {
+		loggerTLS.SetAlwaysFilters(logging.AlwaysFiltersFromSettings(refreshSettings(appCtx)))
+		loggerTLS.AlwaysTraffic.Start(appCtx, logging.DefaultTrafficFlush)
+	}
+	// Rows and bytes written to the DB by each environment, to measure their storage
+	storageCounters := environments.CreateStorageCounters(db.Conn)
+	loggerTLS.SetStorageCounters(storageCounters)
+	storageCounters.Start(appCtx, environments.DefaultCounterFlush)
+	// Store the values of pseudonyms for authorized re-identification
+	loggerTLS.Pseudonyms = environments.CreatePseudonymStore(db.Conn)
+	loggerTLS.Pseudonyms.Start(appCtx, environments.DefaultPseudonymFlush)
+	// Logs that the backend does not accept are kept as dead letters, to be inspected and replayed
+	loggerTLS.DeadLetters = shared.DeadLetters
+	setDeadLetterLimits(loggerTLS.DeadLetters, refreshSettings(appCtx))
+	loggerTLS.SetStatusMaxMessage(int(refreshSettings(appCtx)[settings.StatusMaxMessage].Integer))
+	loggerTLS.StartReplay(appCtx, logging.DefaultReplayInterval)
+	log.Printf("Starting %d workers for logs", logQueueConfig.Workers)
+	loggerTLS.StartQueue(logQueueConfig)
+	// Initialize check-in storm protection, saving the state so it is visible in osctrl-admin
+	stormGuard = handlers.NewStormGuard()
+	stormGuard.OnChange = func(env environments.TLSEnvironment, state environments.StormState) {
+		if state.Active {
+			log.Printf("Check-in storm in %s with %d requests per minute", env.Name, state.Rate)
+		} else {
+			log.Printf("Check-in storm in %s is over", env.Name)
+		}
+		if err := envs.SetStormState(state); err != nil {
+			log.Printf("error saving storm state %v", err)
+		}
+	}
+	// Request statistics of this instance, published so they can be seen by osctrl-api and osctrl-admin
+	requestStats = metrics.NewRequestStats()
+	if instanceName == "" {
+		hostname, _ := os.Hostname()
+		instanceName = hostname + ":" + tlsConfig.Port
+	}
+	go func() {
+		for {
+			select {
+			case <-appCtx.Done():
+				return
+			case <-time.After(time.Duration(defaultRequestStats) * time.Second):
+				if err := redis.SetRequestStats(requestStats.Snapshot(instanceName), 3*time.Duration(defaultRequestStats)*time.Second); err != nil {
+					log.Printf("error publishing request stats %v", err)
+				}
+			}
+		}
+	}()
+	// Connection pools of the database, checked for exhaustion, sent as metrics and published for the stats API
+	if owned {
+		shared.StartPoolMonitor(appCtx, instanceName, settings.ServiceTLS, sendPoolMetrics)
+	}
+	// Send metrics for the log queue, the database retries and the storm protection
+	go func() {
+		for {
+			select {
+			case <-appCtx.Done():
+				return
+			case <-time.After(time.Duration(defaultQueueMetrics) * time.Second):
+				sendQueueMetrics(loggerTLS.Queue.Stats())
+				if handlersTLS != nil && handlersTLS.CarveQueue != nil {
+					sendCarveQueueMetrics(handlersTLS.CarveQueue.Stats())
+				}
+				sendRetryMetrics(backend.GetRetryStats())
+				sendDeadLetterMetrics(loggerTLS.DeadLetters)
+				sendRepeatMetrics(loggerTLS)
+				sendStatusMetrics(loggerTLS)
+				sendS3Metrics(loggerTLS)
+				if eventBus != nil {
+					sendEventMetrics(eventBus)
+				}
+				checkStorms(stormGuard)
+			}
+		}
+	}()
+
+	// Sleep to reload environments, refreshes are splayed so instances do not refresh at the same time.
+	// With redis the map is shared, only one instance refreshes it from the DB while it is cached.
+	log.Println("Preparing pseudo-cache for environments")
+	go func() {
+		_t := settingsmgr.RefreshEnvs(settings.ServiceTLS)
+		if _t == 0 {
+			_t = int64(defaultRefresh)
+		}
+		first := true
+		for {
+			if settingsmgr.DebugService(settings.ServiceTLS) {
+				log.Println("DebugService: Refreshing environments")
+			}
+			envsmap = refreshEnvironments(appCtx)
+			applyStorageQuotas(envsmap)
+			loggerTLS.SetPseudonymizers(envsmap)
+			wait := refreshWait(time.Duration(_t)*time.Second, settingsmgr.RefreshSplay(settings.ServiceTLS), first, rand.Int63n)
+			first = false
+			select {
+			case <-appCtx.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
+	}()
+	// Sleep to reload settings, splayed the same way as environments
+	// FIXME Implement Redis cache
+	log.Println("Preparing pseudo-cache for settings")
+	go func() {
+		_t := settingsmgr.RefreshSettings(settings.ServiceTLS)
+		if _t == 0 {
+			_t = int64(defaultRefresh)
+		}
+		first := true
+		for {
+			if settingsmgr.DebugService(settings.ServiceTLS) {
+				log.Println("DebugService: Refreshing settings")
+			}
+			settingsmap = refreshSettings(appCtx)
+			applySettings(settingsmap)
+			if err := responsesmgr.RefreshMutes(); err != nil {
+				log.Printf("error refreshing mutes %v", err)
+			}
+			wait := refreshWait(time.Duration(_t)*time.Second, settingsmgr.RefreshSplay(settings.ServiceTLS), first, rand.Int63n)
+			first = false
+			select {
+			case <-appCtx.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
+	}()
+	// Initialize TLS handlers before router
+	handlersTLS = handlers.CreateHandlersTLS(
+		handlers.WithEnvs(envs),
+		handlers.WithEnvsMap(&envsmap),
+		handlers.WithNodes(nodesmgr),
+		handlers.WithTags(tagsmgr),
+		handlers.WithQueries(queriesmgr),
+		handlers.WithCarves(filecarves),
+		handlers.WithSettings(settingsmgr),
+		handlers.WithSettingsMap(&settingsmap),
+		handlers.WithMetrics(tlsMetrics),
+		handlers.WithIngested(ingestedMetrics),
+		handlers.WithLogs(loggerTLS),
+		handlers.WithStorm(stormGuard),
+		handlers.WithResponses(responsesmgr),
+		handlers.WithRequestStats(requestStats),
+		handlers.WithBodyLimits(handlers.NewBodyLimits(tlsConfig.MaxBodySmallMB, tlsConfig.MaxBodyMB, tlsConfig.MaxBodyCarveMB)),
+		handlers.WithBuildInfo(version.Info(serviceName)),
+	)
+	// Carve blocks are stored by workers, with the concurrency of the carver in use
+	carveQueueConfig.Workers = map[string]int{
+		settings.CarverDB: carveWorkersDB,
+		settings.CarverS3: carveWorkersS3,
+	}
+	log.Printf("Starting %d workers for carve blocks", carveQueueConfig.WorkersFor(filecarves.Carver))
+	handlersTLS.StartCarveQueue(carveQueueConfig)
+	// Changes made in osctrl-admin and osctrl-api are received as events, to reload them without waiting
+	eventBus = cache.NewEventBus(instanceName, &cache.RedisEvents{Redis: redis})
+	for _, kind := range cache.EventKinds {
+		eventBus.Handle(kind, func(event cache.Event) error {
+			return handleEvent(appCtx, event)
+		})
+	}
+	eventBus.Start(appCtx, cache.EventsPoll)
+
+	// ///////////////////////// ALL CONTENT IS UNAUTHENTICATED FOR TLS
+	if settingsmgr.DebugService(settings.ServiceTLS) {
+		log.Println("DebugService: Creating router")
+	}
+	// Create router for TLS endpoint
+	routerTLS := mux.NewRouter()
+	routerTLS.Use(handlersTLS.RequestStatsMiddleware)
+	// TLS: root
+	routerTLS.HandleFunc("/", handlersTLS.RootHandler)
+	// TLS: testing
+	routerTLS.HandleFunc(healthPath, handlersTLS.HealthHandler).Methods("GET")
+	// TLS: version and build
+	routerTLS.HandleFunc(versionPath, handlersTLS.VersionHandler).Methods("GET")
+	// TLS: error
+	routerTLS.HandleFunc(errorPath, handlersTLS.ErrorHandler).Methods("GET")
+	// TLS: Quick enroll/remove script
+	routerTLS.HandleFunc("/{environment}/{secretpath}/{script}", handlersTLS.QuickEnrollHandler).Methods("GET")
+	// TLS: osctrld retrieve flags
+	routerTLS.HandleFunc("/{environment}/"+environments.DefaultFlagsPath, handlersTLS.LimitBody(environments.DefaultFlagsPath, handlersTLS.FlagsHandler)).Methods("POST")
+	// TLS: osctrld retrieve certificate
+	routerTLS.HandleFunc("/{environment}/"+environments.DefaultCertPath, handlersTLS.LimitBody(environments.DefaultCertPath, handlersTLS.CertHandler)).Methods("POST")
+	// TLS: osctrld verification
+	routerTLS.HandleFunc("/{environment}/"+environments.DefaultVerifyPath, handlersTLS.LimitBody(environments.DefaultVerifyPath, handlersTLS.VerifyHandler)).Methods("POST")
+	// TLS: Specific routes for osquery nodes, after osctrld so each environment can use its own paths
+	routerTLS.HandleFunc("/{environment}/{path}", handlersTLS.OsqueryHandler).Methods("POST")
+	// TLS: osctrld retrieve script to install/remove osquery
+	routerTLS.HandleFunc("/{environment}/{action}/{platform}/"+environments.DefaultScriptPath, handlersTLS.LimitBody(environments.DefaultScriptPath, handlersTLS.ScriptHandler)).Methods("POST")
+
+	// ////////////////////////////// Everything is ready at this point!
+	serviceListener := tlsConfig.Listener + ":" + tlsConfig.Port
+	srv := &http.Server{
+		Addr:    serviceListener,
+		Handler: routerTLS,
+	}
+	// Stop gracefully, draining pending logs
+	idleConnsClosed := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		log.Println("Shutting down service...")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), logQueueShutdown)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("error shutting down HTTP server %v", err)
+		}
+		// Requests are drained, background jobs can stop
+		stopJobs()
+		if err := handlersTLS.StopCarveQueue(carveQueueShutdown); err != nil {
+			log.Printf("error stopping carve block queue %v", err)
+		}
+		if err := loggerTLS.StopQueue(logQueueShutdown); err != nil {
+			log.Printf("error stopping log queue %v", err)
+		}
+		loggerTLS.CloseLogger()
+		close(idleConnsClosed)
+	}()
+	if tlsServer {
+		log.Println("TLS Termination is enabled")
+		cfg, err := utils.TLSConfig(utils.TLSOptions{
+			Profile:               tlsProfile,
+			MinVersion:            tlsMinVersion,
+			MaxVersion:            tlsMaxVersion,
+			CipherSuites:          splitValues(tlsCiphers),
+			Curves:                splitValues(tlsCurves),
+			DisableSessionTickets: tlsNoTickets,
+		})
+		if err != nil {
+			log.Fatalf("Invalid TLS configuration - %v", err)
+		}
+		log.Printf("TLS %s", utils.TLSSummary(tlsProfile, cfg))
+		srv.TLSConfig = cfg
+		srv.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler), 0)
+		log.Printf("%s - HTTPS listening %s", version.Info(serviceName), serviceListener)
+		if err := srv.ListenAndServeTLS(tlsCertFile, tlsKeyFile); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	} else {
+		log.Printf("%s - HTTP listening %s", version.Info(serviceName), serviceListener)
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}
+	<-idleConnsClosed
+}
+
+// Action to run when no flags are provided to run checks and prepare data
+func cliAction(c *cli.Context) error {
+	// Load configuration if external JSON config file is used
+	if configFlag {
+		tlsConfig, err = loadConfiguration(serviceConfigFile, settings.ServiceTLS)
+		if err != nil {
+			return fmt.Errorf("Error loading %s - %s", serviceConfigFile, err)
+		}
+	}
+	// Load db configuration if external JSON config file is used
+	if dbFlag {
+		dbConfig, err = backend.LoadConfiguration(dbConfigFile, backend.DBKey)
+		if err != nil {
+			return fmt.Errorf("Failed to load DB configuration - %v", err)
+		}
+	}
+	// Replicas from flags are added to the ones from the configuration file
+	dbConfig.Replicas = append(dbConfig.Replicas, splitValues(dbReplicas)...)
+	// Load redis configuration if external JSON config file is used
+	if redisFlag {
+		redisConfig, err = cache.LoadConfiguration(redisConfigFile, cache.RedisKey)
+		if err != nil {
+			return fmt.Errorf("Failed to load redis configuration - %v", err)
+		}
+	}
+	// Load always logger filters if the JSON config file exists
+	if alwaysLog && alwaysLogFile != "" {
+		if _, err := os.Stat(alwaysLogFile); err == nil {
+			alwaysFilters, err = logging.LoadAlwaysFilters(alwaysLogFile)
+			if err != nil {
+				return fmt.Errorf("Failed to load always logger filters - %v", err)
+			}
+		}
+	}
+	// Load carver configuration if external JSON config file is used
+	if tlsConfig.Carver == settings.CarverS3 {
+		if s3CarverConfig.Bucket != "" {
+			carvers3, err = carves.CreateCarverS3(s3CarverConfig)
+		} else {
+			carvers3, err = carves.CreateCarverS3File(carverConfigFile)
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to initiate s3 carver - %v", err)
+		}
+	}
+	return nil
+}
+
+// CreateShared to initialize the managers with the configuration of this service, to share them with
+// the other services running in the same process
+func CreateShared() (*service.Shared, error) {
+	return service.CreateShared(dbConfig, redisConfig, tlsConfig.Carver, carvers3)
+}
+
+// Configure to parse the arguments of the service and load its configuration
+func Configure(args []string) error {
+	// Initiate CLI and parse arguments
+	app = cli.NewApp()
+	app.Name = serviceName
+	app.Usage = appDescription
+	app.Version = serviceVersion
+	app.Description = appDescription
+	app.Flags = flags
+	// Define this command for help to exit when help flag is passed
+	app.Commands = []*cli.Command{
+		{
+			Name: "help",
+			Action: func(c *cli.Context) error {
+				cli.ShowAppHelpAndExit(c, 0)
+				return nil
+			},
+		},
+	}
+	app.Action = cliAction
+	return app.Run(args)
+}
+
+// Run to start the service until the context is done, with the managers shared by other services
+// in the same process, or with its own managers if shared is nil
+func Run(ctx context.Context, shared *service.Shared) {
+	osctrlService(ctx, shared)
+}
+
+// Main to run the service as osctrl-tls
+func Main() {
+	if err := Configure(os.Args); err != nil {
+		log.Fatal(err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// Service starts!
+	osctrlService(ctx, nil)
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/logging"
+	"github.com/jmpsec/osctrl/metrics"
+	"github.com/jmpsec/osctrl/nodes"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/tls/handlers"
+)
+
+// Function to load metrics for the service
+func loadingMetrics(mgr *settings.Settings) (*metrics.Metrics, error) {
+	// Check if service settings for metrics is ready, initialize if so
+	if !mgr.IsValue(settings.ServiceTLS, settings.ServiceMetrics) {
+		if err := mgr.NewBooleanValue(settings.ServiceTLS, settings.ServiceMetrics, false); err != nil {
+			return nil, fmt.Errorf("Failed to add %s to configuration: %v", settings.ServiceMetrics, err)
+		}
+	} else if mgr.ServiceMetrics(settings.ServiceTLS) {
+		_mCfg, err := metrics.LoadConfiguration()
+		if err != nil {
+			if err := mgr.SetBoolean(false, settings.ServiceTLS, settings.ServiceMetrics); err != nil {
+				return nil, fmt.Errorf("Failed to disable metrics: %v", err)
+			}
+			return nil, fmt.Errorf("Failed to initialize metrics: %v", err)
+		}
+		_m, err := metrics.CreateMetrics(_mCfg.Protocol, _mCfg.Host, _mCfg.Port, serviceName)
+		if err != nil {
+			if err := mgr.SetBoolean(false, settings.ServiceTLS, settings.ServiceMetrics); err != nil {
+				return nil, fmt.Errorf("Failed to disable metrics: %v", err)
+			}
+			return nil, fmt.Errorf("Failed to initialize metrics: %v", err)
+		}
+		return _m, nil
+	}
+	return nil, nil
+}
+
+// Function to load all settings for the service
+func loadingSettings(mgr *settings.Settings) error {
+	// Check if service settings for debug service is ready
+	if !mgr.IsValue(settings.ServiceTLS, settings.DebugService) {
+		if err := mgr.NewBooleanValue(settings.ServiceTLS, settings.DebugService, false); err != nil {
+			return fmt.Errorf("Failed to add %s to configuration: %v", settings.DebugService, err)
+		}
+	}
+	// Check if service settings for accelerated seconds is ready
+	if !mgr.IsValue(settings.ServiceTLS, settings.AcceleratedSeconds) {
+		if err := mgr.NewIntegerValue(settings.ServiceTLS, settings.AcceleratedSeconds, int64(defaultAccelerate)); err != nil {
+			return fmt.Errorf("Failed to add %s to configuration: %v", settings.AcceleratedSeconds, err)
+		}
+	}
+	// Check if service settings for environments refresh is ready
+	if !mgr.IsValue(settings.ServiceTLS, settings.RefreshEnvs) {
+		if err := mgr.NewIntegerValue(settings.ServiceTLS, settings.RefreshEnvs, int64(defaultRefresh)); err != nil {
+			return fmt.Errorf("Failed to add %s to configuration: %v", settings.RefreshEnvs, err)
+		}
+	}
+	// Check if service settings for the splay of refreshes is ready
+	if !mgr.IsValue(settings.ServiceTLS, settings.RefreshSplay) {
+		if err := mgr.NewIntegerValue(settings.ServiceTLS, settings.RefreshSplay, int64(defaultRefreshSplay)); err != nil {
+			return fmt.Errorf("Failed to add %s to configuration: %v", settings.RefreshSplay, err)
+		}
+	}
+	// Check if service settings for enroll/remove oneliner links is ready
+	if !mgr.IsValue(settings.ServiceTLS, settings.OnelinerExpiration) {
+		if err := mgr.NewBooleanValue(settings.ServiceTLS, settings.OnelinerExpiration, defaultOnelinerExpiration); err != nil {
+			return fmt.Errorf("Failed to add %s to configuration: %v", settings.OnelinerExpiration, err)
+		}
+	}
+	// Check if service settings for the carve scanner are ready, empty disables scanning
+	if !mgr.IsValue(settings.ServiceTLS, settings.CarveScanner) {
+		if err := mgr.NewStringValue(settings.ServiceTLS, settings.CarveScanner, ""); err != nil {
+			return fmt.Errorf("Failed to add %s to configuration: %v", settings.CarveScanner, err)
+		}
+	}
+	if !mgr.IsValue(settings.ServiceTLS, settings.CarveScanTimeout) {
+		if err := mgr.NewIntegerValue(settings.ServiceTLS, settings.CarveScanTimeout, int64(defaultCarveScanTimeout)); err != nil {
+			return fmt.Errorf("Failed to add %s to configuration: %v", settings.CarveScanTimeout, err)
+		}
+	}
+	// Check if service settings for enrolls of platforms not allowed are ready
+	if !mgr.IsValue(settings.ServiceTLS, settings.PlatformPolicy) {
+		if err := mgr.NewStringValue(settings.ServiceTLS, settings.PlatformPolicy, environments.DefaultPlatformPolicy); err != nil {
+			return fmt.Errorf("Failed to add %s to configuration: %v", settings.PlatformPolicy, err)
+		}
+	}
+	// Check if service settings for identity binding of nodes are ready, each environment opts in with a policy
+	if !mgr.IsValue(settings.ServiceTLS, settings.IdentityThreshold) {
+		if err := mgr.NewIntegerValue(settings.ServiceTLS, settings.IdentityThreshold, int64(nodes.DefaultIdentityThreshold)); err != nil {
+			return fmt.Errorf("Failed to add %s to configuration: %v", settings.IdentityThreshold, err)
+		}
+	}
+	if !mgr.IsValue(settings.ServiceTLS, settings.IdentityWebhook) {
+		if err := mgr.NewStringValue(settings.ServiceTLS, settings.IdentityWebhook, ""); err != nil {
+			return fmt.Errorf("Failed to add %s to configuration: %v", settings.IdentityWebhook, err)
+		}
+	}
+	// Check if service settings for check-in storm protection are ready, each environment opts in with a threshold
+	stormValues := map[string]int{
+		settings.StormFactor:   environments.DefaultStormFactor,
+		settings.StormPercent:  environments.DefaultStormPercent,
+		settings.StormCooldown: environments.DefaultStormCooldown,
+	}
+	for name, value := range stormValues {
+		if !mgr.IsValue(settings.ServiceTLS, name) {
+			if err := mgr.NewIntegerValue(settings.ServiceTLS, name, int64(value)); err != nil {
+				return fmt.Errorf("Failed to add %s to configuration: %v", name, err)
+			}
+		}
+	}
+	// Check if service settings for the dead letters of failed log deliveries are ready
+	deadLetterValues := map[string]int{
+		settings.DeadLetterEntries: logging.DefaultDeadLetterEntries,
+		settings.DeadLetterDays:    logging.DefaultDeadLetterDays,
+	}
+	for name, value := range deadLetterValues {
+		if !mgr.IsValue(settings.ServiceTLS, name) {
+			if err := mgr.NewIntegerValue(settings.ServiceTLS, name, int64(value)); err != nil {
+				return fmt.Errorf("Failed to add %s to configuration: %v", name, err)
+			}
+		}
+	}
+	// Check if service settings for the maximum size of messages of status logs is ready
+	if !mgr.IsValue(settings.ServiceTLS, settings.StatusMaxMessage) {
+		if err := mgr.NewIntegerValue(settings.ServiceTLS, settings.StatusMaxMessage, int64(logging.DefaultStatusMaxMessage)); err != nil {
+			return fmt.Errorf("Failed to add %s to configuration: %v", settings.StatusMaxMessage, err)
+		}
+	}
+	// Check if service settings to stream the logs of big batches are ready
+	streamValues := map[string]int{
+		settings.LogMaxBody:       logging.DefaultLogMaxBody / (1024 * 1024),
+		settings.LogStreamEntries: logging.DefaultStreamEntries,
+		// Compressed bodies of logs and query results, once decompressed
+		settings.DecompressMaxBody: handlers.DefaultDecompressMaxBody / (1024 * 1024),
+	}
+	for name, value := range streamValues {
+		if !mgr.IsValue(settings.ServiceTLS, name) {
+			if err := mgr.NewIntegerValue(settings.ServiceTLS, name, int64(value)); err != nil {
+				return fmt.Errorf("Failed to add %s to configuration: %v", name, err)
+			}
+		}
+	}
+	// Check if service settings for the maximum size of request bodies are ready, seeded from the configuration
+	limits := handlers.NewBodyLimits(tlsConfig.MaxBodySmallMB, tlsConfig.MaxBodyMB, tlsConfig.MaxBodyCarveMB)
+	bodyValues := map[string]int64{
+		settings.MaxBody:      limits.Logs,
+		settings.MaxBodySmall: limits.Small,
+		settings.MaxBodyCarve: limits.Carve,
+	}
+	for name, value := range bodyValues {
+		if !mgr.IsValue(settings.ServiceTLS, name) {
+			if err := mgr.NewIntegerValue(settings.ServiceTLS, name, value); err != nil {
+				return fmt.Errorf("Failed to add %s to configuration: %v", name, err)
+			}
+		}
+	}
+	// Check if service settings for always logger filters are ready, seeded from the always config
+	alwaysValues := map[string][]string{
+		settings.AlwaysLogTypes:    alwaysFilters.Types,
+		settings.AlwaysLogNames:    alwaysFilters.Names,
+		settings.AlwaysLogDisabled: alwaysFilters.DisabledEnvs,
+	}
+	for name, value := range alwaysValues {
+		if !mgr.IsValue(settings.ServiceTLS, name) {
+			if err := mgr.NewStringValue(settings.ServiceTLS, name, strings.Join(value, ",")); err != nil {
+				return fmt.Errorf("Failed to add %s to configuration: %v", name, err)
+			}
+		}
+	}
+	// Write JSON config to settings
+	if err := mgr.SetTLSJSON(tlsConfig); err != nil {
+		return fmt.Errorf("Failed to add JSON values to configuration: %v", err)
+	}
+	return nil
+}
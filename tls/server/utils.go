@@ -0,0 +1,427 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmpsec/osctrl/backend"
+	"github.com/jmpsec/osctrl/cache"
+	"github.com/jmpsec/osctrl/carves"
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/jmpsec/osctrl/logging"
+	"github.com/jmpsec/osctrl/settings"
+	"github.com/jmpsec/osctrl/tls/handlers"
+)
+
+// Helper to determine if an IPv4 is public, based on the following:
+// Class   Starting IPAddress  Ending IPAddress
+// A       		10.0.0.0       	 10.255.255.255
+// B       		172.16.0.0       172.31.255.255
+// C       		192.168.0.0      192.168.255.255
+// Link-local 169.254.0.0      169.254.255.255
+// Local      127.0.0.0        127.255.255.255
+/*
+func isPublicIP(ip net.IP) bool {
+	// Use native functions
+	if ip.IsLoopback() || ip.IsLinkLocalMulticast() || ip.IsLinkLocalUnicast() {
+		return false
+	}
+	// Check each octet
+	if ip4 := ip.To4(); ip4 != nil {
+		switch {
+		case ip4[0] == 10:
+			return false
+		case ip4[0] == 172 && ip4[1] >= 16 && ip4[1] <= 31:
+			return false
+		case ip4[0] == 192 && ip4[1] == 168:
+			return false
+		default:
+			return true
+		}
+	}
+	return false
+}
+*/
+
+// Helper to refresh the environments map. With redis, one instance refreshes it from the DB and shares it
+// with the others, so all instances use the same map. The DB is used directly when redis is not available.
+func refreshEnvironments(ctx context.Context) environments.MapEnvironments {
+	log.Printf("Refreshing environments...\n")
+	share := false
+	if redis != nil {
+		_envsmap, refresh, err := sharedEnvironments(ctx)
+		switch {
+		case err != nil:
+			log.Printf("error getting cached environments, refreshing from DB %v\n", err)
+		case !refresh:
+			// Secrets of environments are never shared in redis, they are loaded from the DB when environments change
+			if !envsSecrets.apply(_envsmap) {
+				if err := envs.LoadSecretsCtx(ctx, _envsmap); err != nil {
+					log.Printf("error loading secrets of environments %v\n", err)
+					return environments.MapEnvironments{}
+				}
+				envsSecrets.store(_envsmap)
+			}
+			return _envsmap
+		default:
+			share = true
+		}
+	}
+	_envsmap, err := envs.GetMapCtx(ctx)
+	if err != nil {
+		log.Printf("error refreshing environments %v\n", err)
+		return environments.MapEnvironments{}
+	}
+	envsSecrets.store(_envsmap)
+	if share {
+		if err := redis.SetEnvsCached(ctx, _envsmap, 0); err != nil {
+			log.Printf("error caching environments %v\n", err)
+		}
+	}
+	return _envsmap
+}
+
+// Secrets of one environment, with the last update of the environment when they were loaded
+type cachedSecrets struct {
+	environments.EnvSecrets
+	UpdatedAt time.Time
+}
+
+// envSecrets to keep the secrets of environments in memory, so they are only loaded again when environments change
+type envSecrets struct {
+	mux     sync.Mutex
+	secrets map[uint]cachedSecrets
+}
+
+// Helper to add the kept secrets to the environments, false if any environment changed since they were loaded
+func (c *envSecrets) apply(envsmap environments.MapEnvironments) bool {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	for _, env := range envsmap {
+		s, ok := c.secrets[env.ID]
+		if !ok || !s.UpdatedAt.Equal(env.UpdatedAt) {
+			return false
+		}
+	}
+	for key, env := range envsmap {
+		s := c.secrets[env.ID]
+		env.Secret = s.Secret
+		env.EnrollSecretPath = s.EnrollSecretPath
+		env.RemoveSecretPath = s.RemoveSecretPath
+		env.Certificate = s.Certificate
+		env.PseudonymKey = s.PseudonymKey
+		envsmap[key] = env
+	}
+	return true
+}
+
+// Helper to keep the secrets of the environments loaded from the DB, replacing the ones kept before
+func (c *envSecrets) store(envsmap environments.MapEnvironments) {
+	secrets := make(map[uint]cachedSecrets, len(envsmap))
+	for _, env := range envsmap {
+		secrets[env.ID] = cachedSecrets{
+			EnvSecrets: environments.EnvSecrets{
+				ID:               env.ID,
+				Secret:           env.Secret,
+				EnrollSecretPath: env.EnrollSecretPath,
+				RemoveSecretPath: env.RemoveSecretPath,
+				Certificate:      env.Certificate,
+				PseudonymKey:     env.PseudonymKey,
+			},
+			UpdatedAt: env.UpdatedAt,
+		}
+	}
+	c.mux.Lock()
+	c.secrets = secrets
+	c.mux.Unlock()
+}
+
+// Helper to get the environments map shared in redis. When it is not cached, the instance that claims the
+// refresh gets it from the DB, and the rest wait for it a little before getting it from the DB themselves.
+func sharedEnvironments(ctx context.Context) (environments.MapEnvironments, bool, error) {
+	for i := 0; ; i++ {
+		_envsmap, ok, err := redis.GetEnvsCached(ctx)
+		if err != nil {
+			return nil, false, err
+		}
+		if ok {
+			return _envsmap, false, nil
+		}
+		claimed, err := redis.ClaimEnvsRefresh(ctx, instanceName)
+		if err != nil {
+			return nil, false, err
+		}
+		if claimed || i >= envsRefreshTries {
+			return nil, true, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		case <-time.After(envsRefreshPause):
+		}
+	}
+}
+
+// Helper to get how long to wait for the next refresh of a pseudo-cache, with the splay as a percentage of
+// the interval. The first wait of each instance adds a random part of the splay, so instances behind a load
+// balancer do not refresh at the same moment, and every wait changes by up to a tenth of the splay.
+func refreshWait(interval time.Duration, percent int64, first bool, random func(int64) int64) time.Duration {
+	if percent <= 0 {
+		return interval
+	}
+	if percent > 100 {
+		percent = 100
+	}
+	splay := interval * time.Duration(percent) / 100
+	if first {
+		if splay > 0 {
+			interval += time.Duration(random(int64(splay)))
+		}
+		return interval
+	}
+	if jitter := splay / 10; jitter > 0 {
+		interval += time.Duration(random(int64(2*jitter+1))) - jitter
+	}
+	return interval
+}
+
+// Helper to pause the always logger in the environments with an exceeded storage quota that pauses it
+func applyStorageQuotas(envsmap environments.MapEnvironments) {
+	if !alwaysLog {
+		return
+	}
+	quotas, err := envs.StorageQuotas()
+	if err != nil {
+		log.Printf("error getting storage quotas %v", err)
+		return
+	}
+	var paused []string
+	for key, env := range envsmap {
+		// Environments are in the map by name and by UUID
+		if key != env.Name {
+			continue
+		}
+		if quotas[env.ID].Active(environments.QuotaPauseAlwaysLog) {
+			paused = append(paused, env.Name)
+		}
+	}
+	loggerTLS.SetAlwaysPaused(paused)
+}
+
+// Helper to refresh the settings until cache/Redis support is implemented
+func refreshSettings(ctx context.Context) settings.MapSettings {
+	log.Printf("Refreshing settings...\n")
+	_settingsmap, err := settingsmgr.GetMapCtx(ctx, settings.ServiceTLS)
+	if err != nil {
+		log.Printf("error refreshing settings %v\n", err)
+		return settings.MapSettings{}
+	}
+	return _settingsmap
+}
+
+// Helper to send the metrics for the log queue, if metrics are enabled
+func sendQueueMetrics(stats logging.QueueStats) {
+	if tlsMetrics == nil || !settingsmgr.ServiceMetrics(settings.ServiceTLS) {
+		return
+	}
+	tlsMetrics.ConnectAndSend("log-queue-depth", stats.Depth)
+	tlsMetrics.ConnectAndSend("log-queue-rejected", stats.Rejected)
+	for env, processed := range stats.Processed {
+		tlsMetrics.ConnectAndSend("log-queue-processed."+env, processed)
+	}
+	for env, pending := range stats.Pending {
+		tlsMetrics.ConnectAndSend("log-queue-pending."+env, pending)
+	}
+}
+
+// Helper to send the metrics for the carve block queue, if metrics are enabled
+func sendCarveQueueMetrics(stats carves.BlockQueueStats) {
+	if tlsMetrics == nil || !settingsmgr.ServiceMetrics(settings.ServiceTLS) {
+		return
+	}
+	tlsMetrics.ConnectAndSend("carve-queue-depth", stats.Depth)
+	tlsMetrics.ConnectAndSend("carve-queue-rejected", stats.Rejected)
+	tlsMetrics.ConnectAndSend("carve-queue-stored", stats.Processed)
+	tlsMetrics.ConnectAndSend("carve-queue-failed", stats.Failed)
+	tlsMetrics.ConnectAndSend("carve-queue-latency", stats.Latency)
+	tlsMetrics.ConnectAndSend("carve-queue-latency-max", stats.MaxLatency)
+}
+
+// Helper to send the retry counters of the database operations, if metrics are enabled
+func sendRetryMetrics(stats map[string]backend.RetryStats) {
+	if tlsMetrics == nil || !settingsmgr.ServiceMetrics(settings.ServiceTLS) {
+		return
+	}
+	for op, s := range stats {
+		tlsMetrics.ConnectAndSend("db-retry."+op+".retries", int(s.Retries))
+		tlsMetrics.ConnectAndSend("db-retry."+op+".recovered", int(s.Recovered))
+		tlsMetrics.ConnectAndSend("db-retry."+op+".exhausted", int(s.Exhausted))
+		tlsMetrics.ConnectAndSend("db-retry."+op+".failed", int(s.Failed))
+	}
+}
+
+// Helper to send the statistics of the database connection pools, if metrics are enabled
+func sendPoolMetrics(stats []backend.PoolStats) {
+	if tlsMetrics == nil || !settingsmgr.ServiceMetrics(settings.ServiceTLS) {
+		return
+	}
+	for _, s := range stats {
+		pool := "db-pool." + strings.NewReplacer(".", "_", ":", "_").Replace(s.Pool)
+		tlsMetrics.ConnectAndSend(pool+".open", s.Open)
+		tlsMetrics.ConnectAndSend(pool+".in-use", s.InUse)
+		tlsMetrics.ConnectAndSend(pool+".idle", s.Idle)
+		tlsMetrics.ConnectAndSend(pool+".wait-count", int(s.WaitCount))
+		tlsMetrics.ConnectAndSend(pool+".wait-ms", int(s.WaitDuration.Milliseconds()))
+		tlsMetrics.ConnectAndSend(pool+".max-lifetime-closed", int(s.MaxLifetimeClosed))
+		tlsMetrics.ConnectAndSend(pool+".max-idle-time-closed", int(s.MaxIdleTimeClosed))
+	}
+}
+
+// SendPoolMetrics to send the statistics of the shared connection pools when services run in one process
+func SendPoolMetrics(stats []backend.PoolStats) {
+	sendPoolMetrics(stats)
+}
+
+// Helper to send the dead letters evicted to keep them under the limits, if metrics are enabled
+func sendDeadLetterMetrics(deadLetters *logging.DeadLetters) {
+	if tlsMetrics == nil || !settingsmgr.ServiceMetrics(settings.ServiceTLS) {
+		return
+	}
+	tlsMetrics.ConnectAndSend("dead-letter-evicted", deadLetters.Evicted())
+}
+
+// Helper to send the on-demand query results sent again by nodes and the ones replaced, if metrics are enabled
+func sendRepeatMetrics(loggerTLS *logging.LoggerTLS) {
+	repeated, replaced := loggerTLS.Repeats()
+	if tlsMetrics == nil || !settingsmgr.ServiceMetrics(settings.ServiceTLS) {
+		return
+	}
+	tlsMetrics.ConnectAndSend("query-write-repeated", repeated)
+	tlsMetrics.ConnectAndSend("query-write-replaced", replaced)
+}
+
+// Helper to send the status logs truncated or skipped and the batches that could not be parsed, if metrics are enabled
+func sendStatusMetrics(loggerTLS *logging.LoggerTLS) {
+	truncated, skipped, invalid := loggerTLS.StatusIssues()
+	if tlsMetrics == nil || !settingsmgr.ServiceMetrics(settings.ServiceTLS) {
+		return
+	}
+	tlsMetrics.ConnectAndSend("status-truncated", truncated)
+	tlsMetrics.ConnectAndSend("status-skipped", skipped)
+	tlsMetrics.ConnectAndSend("status-invalid", invalid)
+}
+
+// Helper to send the objects and bytes written by the S3 logger for each environment, if metrics are enabled
+func sendS3Metrics(loggerTLS *logging.LoggerTLS) {
+	stats, ok := loggerTLS.S3Stats()
+	if !ok || tlsMetrics == nil || !settingsmgr.ServiceMetrics(settings.ServiceTLS) {
+		return
+	}
+	for env, objects := range stats.Objects {
+		tlsMetrics.ConnectAndSend("s3-objects."+env, objects)
+	}
+	for env, written := range stats.Bytes {
+		tlsMetrics.ConnectAndSend("s3-bytes."+env, written)
+	}
+	tlsMetrics.ConnectAndSend("s3-failed", stats.Failed)
+}
+
+// Helper to send the counters of the events received from other services, if metrics are enabled
+func sendEventMetrics(bus *cache.EventBus) {
+	stats := bus.Stats()
+	if tlsMetrics == nil || !settingsmgr.ServiceMetrics(settings.ServiceTLS) {
+		return
+	}
+	tlsMetrics.ConnectAndSend("events-delivered", stats.Delivered)
+	tlsMetrics.ConnectAndSend("events-duplicated", stats.Duplicates)
+	tlsMetrics.ConnectAndSend("events-failed", stats.Failed)
+	tlsMetrics.ConnectAndSend("events-resync", stats.Resyncs)
+	tlsMetrics.ConnectAndSend("events-reconnect", stats.Reconnects)
+}
+
+// Helper to apply the settings that components of the logger keep
+func applySettings(values settings.MapSettings) {
+	if alwaysLog && len(values) > 0 {
+		loggerTLS.SetAlwaysFilters(logging.AlwaysFiltersFromSettings(values))
+	}
+	setDeadLetterLimits(loggerTLS.DeadLetters, values)
+	loggerTLS.SetStatusMaxMessage(int(values[settings.StatusMaxMessage].Integer))
+}
+
+// Helper to reload what another service changed when an event is received. Reads use the primary for a while,
+// so replicas that did not replay the change yet are not used. Pending on-demand queries are always read from
+// the DB, reading them from the primary is enough for nodes to get new queries right away.
+func handleEvent(ctx context.Context, event cache.Event) error {
+	db.ReadPrimary(backend.DefaultPrimaryWindow)
+	switch event.Kind {
+	case cache.EventEnvironment:
+		_envsmap, err := envs.GetMapCtx(ctx)
+		if err != nil {
+			return fmt.Errorf("error refreshing environments %v", err)
+		}
+		envsmap = _envsmap
+		envsSecrets.store(envsmap)
+		if redis != nil {
+			if err := redis.SetEnvsCached(ctx, envsmap, 0); err != nil {
+				log.Printf("error caching environments %v", err)
+			}
+		}
+		applyStorageQuotas(envsmap)
+		loggerTLS.SetPseudonymizers(envsmap)
+		handlersTLS.InvalidateEnvironment(event.Environment)
+	case cache.EventSettings:
+		_settingsmap, err := settingsmgr.GetMapCtx(ctx, settings.ServiceTLS)
+		if err != nil {
+			return fmt.Errorf("error refreshing settings %v", err)
+		}
+		settingsmap = _settingsmap
+		applySettings(settingsmap)
+	}
+	if settingsmgr.DebugService(settings.ServiceTLS) {
+		log.Printf("DebugService: %s event %d from %s", event.Kind, event.Version, event.Emitter)
+	}
+	return nil
+}
+
+// Helper to apply the limits of the dead letters from the settings
+func setDeadLetterLimits(deadLetters *logging.DeadLetters, values settings.MapSettings) {
+	deadLetters.SetLimits(int(values[settings.DeadLetterEntries].Integer), int(values[settings.DeadLetterDays].Integer))
+}
+
+// Helper to split a comma separated list of values, ignoring empty ones
+func splitValues(list string) []string {
+	var values []string
+	for _, v := range strings.Split(list, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// Helper to re-evaluate the storm protection of all environments, so storms end without traffic, and
+// send the storm metrics, if metrics are enabled
+func checkStorms(guard *handlers.StormGuard) {
+	cooldown := environments.DefaultStormCooldown
+	if s, ok := settingsmap[settings.StormCooldown]; ok && s.Integer > 0 {
+		cooldown = int(s.Integer)
+	}
+	for _, env := range envsmap {
+		guard.Check(env, time.Duration(cooldown)*time.Second)
+	}
+	if tlsMetrics == nil || !settingsmgr.ServiceMetrics(settings.ServiceTLS) {
+		return
+	}
+	for env, s := range guard.Stats() {
+		active := 0
+		if s.Active {
+			active = 1
+		}
+		tlsMetrics.ConnectAndSend("storm-active."+env, active)
+		tlsMetrics.ConnectAndSend("storm-rate."+env, s.Rate)
+	}
+}
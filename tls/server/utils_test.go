@@ -0,0 +1,57 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/jmpsec/osctrl/environments"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestRefreshWait(t *testing.T) {
+	interval := 300 * time.Second
+	lowest := func(n int64) int64 { return 0 }
+	highest := func(n int64) int64 { return n - 1 }
+	// Without splay the interval is used as it is
+	assert.Equal(t, interval, refreshWait(interval, 0, true, highest))
+	// The first wait is offset by up to the splay
+	assert.Equal(t, interval, refreshWait(interval, 10, true, lowest))
+	assert.Equal(t, interval+30*time.Second-1, refreshWait(interval, 10, true, highest))
+	// Later waits change by up to a tenth of the splay
+	assert.Equal(t, interval-3*time.Second, refreshWait(interval, 10, false, lowest))
+	assert.Equal(t, interval+3*time.Second, refreshWait(interval, 10, false, highest))
+	// The splay is never more than the interval
+	assert.Equal(t, 2*interval-1, refreshWait(interval, 250, true, highest))
+}
+
+// Helper to get the environments as they are shared in redis, without secrets
+func sharedEnvs(t *testing.T, envsmap environments.MapEnvironments) environments.MapEnvironments {
+	raw, err := json.Marshal(envsmap.WithoutSecrets())
+	assert.NoError(t, err)
+	var shared environments.MapEnvironments
+	assert.NoError(t, json.Unmarshal(raw, &shared))
+	return shared
+}
+
+func TestEnvSecrets(t *testing.T) {
+	updated := time.Now()
+	dev := environments.TLSEnvironment{Model: gorm.Model{ID: 1, UpdatedAt: updated}, Name: "dev", Secret: "secret", Certificate: "cert", PseudonymKey: "key"}
+	loaded := environments.MapEnvironments{"dev": dev, "uuid-dev": dev}
+	var c envSecrets
+	// Nothing is kept until secrets are loaded from the DB
+	assert.False(t, c.apply(sharedEnvs(t, loaded)))
+	c.store(loaded)
+	shared := sharedEnvs(t, loaded)
+	assert.Empty(t, shared["dev"].Secret)
+	assert.True(t, c.apply(shared))
+	assert.Equal(t, "secret", shared["dev"].Secret)
+	assert.Equal(t, "cert", shared["uuid-dev"].Certificate)
+	assert.Equal(t, "key", shared["dev"].PseudonymKey)
+	// Secrets are loaded again when an environment changes or is added
+	dev.UpdatedAt = updated.Add(time.Second)
+	assert.False(t, c.apply(sharedEnvs(t, environments.MapEnvironments{"dev": dev})))
+	prod := environments.TLSEnvironment{Model: gorm.Model{ID: 2, UpdatedAt: updated}, Name: "prod"}
+	assert.False(t, c.apply(sharedEnvs(t, environments.MapEnvironments{"dev": loaded["dev"], "prod": prod})))
+}
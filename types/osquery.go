@@ -126,6 +126,10 @@ type LogDecorations struct {
 	OsqueryVersion string `json:"osquery_version"`
 	ConfigHash     string `json:"config_hash"`
 	DaemonHash     string `json:"osquery_md5"`
+	HardwareUUID   string `json:"hardware_uuid"`
+	CPUBrand       string `json:"cpu_brand"`
+	OSPlatform     string `json:"os_platform"`
+	MACAddresses   string `json:"mac_addresses"`
 }
 
 // StringInt to parse numbers that could be strings
@@ -149,17 +153,27 @@ func (si *StringInt) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
-// LogResultData to be used processing result logs from nodes
+// LogResultData to be used processing result logs from nodes, in any of the formats of osquery:
+// one row with its action (event), added and removed rows (batch) or all the rows (snapshot)
 type LogResultData struct {
-	Name           string          `json:"name"`
-	Epoch          int64           `json:"epoch"`
-	Action         string          `json:"action"`
-	Columns        json.RawMessage `json:"columns"`
-	Counter        int             `json:"counter"`
-	UnixTime       StringInt       `json:"unixTime"`
-	Decorations    LogDecorations  `json:"decorations"`
-	CalendarTime   string          `json:"calendarTime"`
-	HostIdentifier string          `json:"hostIdentifier"`
+	Name           string            `json:"name"`
+	Epoch          StringInt         `json:"epoch"`
+	Action         string            `json:"action"`
+	Columns        json.RawMessage   `json:"columns,omitempty"`
+	DiffResults    *LogDiffResults   `json:"diffResults,omitempty"`
+	Snapshot       []json.RawMessage `json:"snapshot,omitempty"`
+	Counter        StringInt         `json:"counter"`
+	Numerics       bool              `json:"numerics"`
+	UnixTime       StringInt         `json:"unixTime"`
+	Decorations    LogDecorations    `json:"decorations"`
+	CalendarTime   string            `json:"calendarTime"`
+	HostIdentifier string            `json:"hostIdentifier"`
+}
+
+// LogDiffResults for the rows of result logs in batch format
+type LogDiffResults struct {
+	Added   []json.RawMessage `json:"added"`
+	Removed []json.RawMessage `json:"removed"`
 }
 
 // LogStatusData to be used processing status logs from nodes
@@ -180,6 +194,8 @@ type LogGenericData struct {
 	HostIdentifier string         `json:"hostIdentifier"`
 	Decorations    LogDecorations `json:"decorations"`
 	Version        string         `json:"version"`
+	UnixTime       StringInt      `json:"unixTime"`
+	CalendarTime   string         `json:"calendarTime"`
 }
 
 // QueryReadRequest received to get on-demand queries
@@ -208,11 +224,24 @@ type QueryWriteStatuses map[string]int
 // QueryWriteMessages to hold the on-demand queries messages
 type QueryWriteMessages map[string]string
 
+// QueryWriteStat to hold the execution stats of one on-demand query
+type QueryWriteStat struct {
+	WallTime   int64 `json:"wall_time"`
+	WallTimeMs int64 `json:"wall_time_ms"`
+	UserTime   int64 `json:"user_time"`
+	SystemTime int64 `json:"system_time"`
+	Memory     int64 `json:"memory"`
+}
+
+// QueryWriteStats to hold the on-demand queries execution stats, if reported by osquery
+type QueryWriteStats map[string]QueryWriteStat
+
 // QueryWriteRequest to receive on-demand queries results
 type QueryWriteRequest struct {
 	Queries  QueryWriteQueries  `json:"queries"`
 	Statuses QueryWriteStatuses `json:"statuses"`
 	Messages QueryWriteMessages `json:"messages"`
+	Stats    QueryWriteStats    `json:"stats"`
 	NodeKey  string             `json:"node_key"`
 }
 
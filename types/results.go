@@ -0,0 +1,206 @@
+package types
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Formats of result logs sent by osquery
+const (
+	// ResultFormatEvent for one row with its action, the default
+	ResultFormatEvent string = "event"
+	// ResultFormatBatch for added and removed rows together, with logger_event_type disabled
+	ResultFormatBatch string = "batch"
+	// ResultFormatSnapshot for all the rows of a snapshot query
+	ResultFormatSnapshot string = "snapshot"
+)
+
+// Types of result events
+const (
+	// EventTypeDifferential for rows that happened at a time, added or removed since the last run
+	EventTypeDifferential string = "differential"
+	// EventTypeSnapshot for rows that were true at a time, the full results of a snapshot query
+	EventTypeSnapshot string = "snapshot"
+)
+
+// Actions of result events
+const (
+	ResultActionAdded    string = "added"
+	ResultActionRemoved  string = "removed"
+	ResultActionSnapshot string = "snapshot"
+)
+
+// ResultActions to list all the actions of result events
+var ResultActions = []string{ResultActionAdded, ResultActionRemoved, ResultActionSnapshot}
+
+// Layout of calendarTime in result logs
+const calendarTimeLayout = "Mon Jan _2 15:04:05 2006 MST"
+
+// ResultRow to hold one row of a result log with its action, columns as sent by osquery
+type ResultRow struct {
+	Action  string
+	Columns json.RawMessage
+}
+
+// ResultEvent to hold one normalized row of results, the same for all the formats of osquery.
+// Columns are always strings, as osquery sends them unless the numerics option is enabled.
+// The ID is only set for events stored in the database. Time is when the server received the
+// event, also only known once stored, and AgentTime is the time reported by the node.
+type ResultEvent struct {
+	ID        uint              `json:"id,omitempty"`
+	Name      string            `json:"name"`
+	Node      string            `json:"node"`
+	Format    string            `json:"format"`
+	EventType string            `json:"event_type"`
+	Action    string            `json:"action"`
+	Time      time.Time         `json:"time"`
+	AgentTime time.Time         `json:"agent_time"`
+	Epoch     int64             `json:"epoch"`
+	Counter   int64             `json:"counter"`
+	Numerics  bool              `json:"numerics"`
+	Columns   map[string]string `json:"columns"`
+}
+
+// ParseResultLogs to parse result logs, as a list like nodes send them or as one log like osquery writes to files
+func ParseResultLogs(data []byte) ([]LogResultData, error) {
+	var logs []LogResultData
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		var l LogResultData
+		if err := json.Unmarshal(trimmed, &l); err != nil {
+			return logs, err
+		}
+		return append(logs, l), nil
+	}
+	if err := json.Unmarshal(trimmed, &logs); err != nil {
+		return logs, err
+	}
+	return logs, nil
+}
+
+// Format to get the format of a result log
+func (l LogResultData) Format() string {
+	if l.Snapshot != nil {
+		return ResultFormatSnapshot
+	}
+	if l.DiffResults != nil {
+		return ResultFormatBatch
+	}
+	return ResultFormatEvent
+}
+
+// AgentTime to get the time reported by a node in a log, from unixTime or calendarTime.
+// It is zero when the log has no valid time.
+func AgentTime(unixTime StringInt, calendarTime string) time.Time {
+	if unixTime > 0 {
+		return time.Unix(int64(unixTime), 0).UTC()
+	}
+	if t, err := time.Parse(calendarTimeLayout, calendarTime); err == nil {
+		return t.UTC()
+	}
+	return time.Time{}
+}
+
+// Time to get the time of a result log as reported by the node
+func (l LogResultData) Time() time.Time {
+	return AgentTime(l.UnixTime, l.CalendarTime)
+}
+
+// Time to get the time of a status or result log as reported by the node
+func (l LogGenericData) Time() time.Time {
+	return AgentTime(l.UnixTime, l.CalendarTime)
+}
+
+// Rows to get the rows of a result log with their action, removed rows of batches come first
+func (l LogResultData) Rows() []ResultRow {
+	var rows []ResultRow
+	switch l.Format() {
+	case ResultFormatSnapshot:
+		for _, c := range l.Snapshot {
+			rows = append(rows, ResultRow{Action: ResultActionSnapshot, Columns: c})
+		}
+	case ResultFormatBatch:
+		for _, c := range l.DiffResults.Removed {
+			rows = append(rows, ResultRow{Action: ResultActionRemoved, Columns: c})
+		}
+		for _, c := range l.DiffResults.Added {
+			rows = append(rows, ResultRow{Action: ResultActionAdded, Columns: c})
+		}
+	default:
+		rows = append(rows, ResultRow{Action: l.Action, Columns: l.Columns})
+	}
+	return rows
+}
+
+// Events to get the normalized events of a result log, one for each row, with the time of the node.
+// Rows with invalid columns are skipped and the first error is returned.
+func (l LogResultData) Events() ([]ResultEvent, error) {
+	var events []ResultEvent
+	var firstErr error
+	for _, r := range l.Rows() {
+		columns, err := NormalizeColumns(r.Columns)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("invalid columns in %s - %v", l.Name, err)
+			}
+			continue
+		}
+		events = append(events, ResultEvent{
+			Name:      l.Name,
+			Node:      l.HostIdentifier,
+			Format:    l.Format(),
+			EventType: EventType(r.Action),
+			Action:    r.Action,
+			AgentTime: l.Time(),
+			Epoch:     int64(l.Epoch),
+			Counter:   int64(l.Counter),
+			Numerics:  l.Numerics,
+			Columns:   columns,
+		})
+	}
+	return events, firstErr
+}
+
+// EventType to get the type of event for the action of a row
+func EventType(action string) string {
+	if action == ResultActionSnapshot {
+		return EventTypeSnapshot
+	}
+	return EventTypeDifferential
+}
+
+// NormalizeColumns to get the columns of a row as strings, with or without the numerics option
+func NormalizeColumns(raw json.RawMessage) (map[string]string, error) {
+	columns := make(map[string]string)
+	if len(bytes.TrimSpace(raw)) == 0 {
+		return columns, nil
+	}
+	var values map[string]interface{}
+	d := json.NewDecoder(bytes.NewReader(raw))
+	d.UseNumber()
+	if err := d.Decode(&values); err != nil {
+		return columns, err
+	}
+	for k, v := range values {
+		switch value := v.(type) {
+		case nil:
+			columns[k] = ""
+		case string:
+			columns[k] = value
+		case json.Number:
+			columns[k] = value.String()
+		case bool:
+			columns[k] = strconv.FormatBool(value)
+		default:
+			b, err := json.Marshal(value)
+			if err != nil {
+				return columns, err
+			}
+			columns[k] = string(b)
+		}
+	}
+	return columns, nil
+}
@@ -0,0 +1,107 @@
+package types
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper to parse the events of a fixture of result logs
+func fixtureEvents(t *testing.T, file string) []ResultEvent {
+	data, err := os.ReadFile("testdata/" + file)
+	assert.NoError(t, err)
+	logs, err := ParseResultLogs(data)
+	assert.NoError(t, err)
+	var events []ResultEvent
+	for _, l := range logs {
+		e, err := l.Events()
+		assert.NoError(t, err)
+		events = append(events, e...)
+	}
+	return events
+}
+
+func TestResultEventsEvent(t *testing.T) {
+	events := fixtureEvents(t, "result-event.json")
+	assert.Equal(t, 2, len(events))
+	assert.Equal(t, ResultFormatEvent, events[0].Format)
+	assert.Equal(t, EventTypeDifferential, events[0].EventType)
+	assert.Equal(t, ResultActionAdded, events[0].Action)
+	assert.Equal(t, ResultActionRemoved, events[1].Action)
+	assert.Equal(t, "pack_incident-response_listening_ports", events[0].Name)
+	assert.Equal(t, "8D5B2A6E-4F5A-4C2B-9E0A-3B7C1D2E4F60", events[0].Node)
+	assert.Equal(t, time.Unix(1646749351, 0).UTC(), events[0].AgentTime)
+	// Only stored events have the time of the server
+	assert.True(t, events[0].Time.IsZero())
+	assert.Equal(t, int64(12), events[0].Counter)
+	assert.Equal(t, "22", events[0].Columns["port"])
+	assert.Equal(t, "5432", events[1].Columns["port"])
+}
+
+func TestResultEventsNumerics(t *testing.T) {
+	events := fixtureEvents(t, "result-event-numerics.json")
+	assert.Equal(t, 1, len(events))
+	assert.True(t, events[0].Numerics)
+	assert.Equal(t, int64(3), events[0].Epoch)
+	assert.Equal(t, "360698", events[0].Columns["total_seconds"])
+	assert.Equal(t, "true", events[0].Columns["running"])
+	assert.Equal(t, "", events[0].Columns["comment"])
+}
+
+func TestResultEventsLegacy(t *testing.T) {
+	// Single log as written to files, with times and counters as strings
+	events := fixtureEvents(t, "result-event-legacy.json")
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, time.Unix(1641859141, 0).UTC(), events[0].AgentTime)
+	assert.Equal(t, int64(4), events[0].Counter)
+	assert.False(t, events[0].Numerics)
+	assert.Equal(t, "/usr/bin/backup.sh", events[0].Columns["command"])
+}
+
+func TestResultEventsBatch(t *testing.T) {
+	events := fixtureEvents(t, "result-batch.json")
+	assert.Equal(t, 3, len(events))
+	for _, e := range events {
+		assert.Equal(t, ResultFormatBatch, e.Format)
+		assert.Equal(t, EventTypeDifferential, e.EventType)
+		assert.Equal(t, int64(27), e.Counter)
+	}
+	assert.Equal(t, ResultActionRemoved, events[0].Action)
+	assert.Equal(t, "temp-contractor", events[0].Columns["username"])
+	assert.Equal(t, ResultActionAdded, events[1].Action)
+	assert.Equal(t, "svc-backup", events[1].Columns["username"])
+	assert.Equal(t, ResultActionAdded, events[2].Action)
+}
+
+func TestResultEventsSnapshot(t *testing.T) {
+	events := fixtureEvents(t, "result-snapshot.json")
+	assert.Equal(t, 3, len(events))
+	for _, e := range events {
+		assert.Equal(t, ResultFormatSnapshot, e.Format)
+		assert.Equal(t, EventTypeSnapshot, e.EventType)
+		assert.Equal(t, ResultActionSnapshot, e.Action)
+		assert.Equal(t, time.Unix(1646956800, 0).UTC(), e.AgentTime)
+	}
+	assert.Equal(t, "1042", events[1].Columns["pid"])
+	// Same snapshot with numerics, columns are normalized to the same values
+	numerics := fixtureEvents(t, "result-snapshot-numerics.json")
+	assert.Equal(t, 2, len(numerics))
+	assert.Equal(t, events[0].Columns, numerics[0].Columns)
+	assert.Equal(t, events[1].Columns, numerics[1].Columns)
+}
+
+func TestResultLogTime(t *testing.T) {
+	l := LogResultData{CalendarTime: "Tue Mar  8 14:22:31 2022 UTC"}
+	assert.Equal(t, time.Date(2022, 3, 8, 14, 22, 31, 0, time.UTC), l.Time())
+	assert.True(t, LogResultData{}.Time().IsZero())
+}
+
+func TestResultEventsInvalidColumns(t *testing.T) {
+	logs, err := ParseResultLogs([]byte(`[{"name":"bad","diffResults":{"added":[{"a":"1"},"broken"]}}]`))
+	assert.NoError(t, err)
+	events, err := logs[0].Events()
+	assert.Error(t, err)
+	assert.Equal(t, 1, len(events))
+}
@@ -1,5 +1,11 @@
 package types
 
+import (
+	"time"
+
+	"github.com/jmpsec/osctrl/queries"
+)
+
 // JSONConfigurationTLS to hold TLS service configuration values
 type JSONConfigurationTLS struct {
 	Listener string `json:"listener"`
@@ -8,6 +14,10 @@ type JSONConfigurationTLS struct {
 	Auth     string `json:"auth"`
 	Logger   string `json:"logger"`
 	Carver   string `json:"carver"`
+	// Maximum size in MB of request bodies: log and query write, enroll and config, carve blocks
+	MaxBodyMB      int64 `json:"maxBodyMB"`
+	MaxBodySmallMB int64 `json:"maxBodySmallMB"`
+	MaxBodyCarveMB int64 `json:"maxBodyCarveMB"`
 }
 
 // JSONConfigurationAdmin to hold admin service configuration values
@@ -19,15 +29,17 @@ type JSONConfigurationAdmin struct {
 	Logger     string `json:"logger"`
 	Carver     string `json:"carver"`
 	SessionKey string `json:"sessionKey"`
+	URLPrefix  string `json:"urlPrefix"`
 }
 
 // JSONConfigurationAPI to hold API service configuration values
 type JSONConfigurationAPI struct {
-	Listener string `json:"listener"`
-	Port     string `json:"port"`
-	Host     string `json:"host"`
-	Auth     string `json:"auth"`
-	Carver   string `json:"carver"`
+	Listener  string `json:"listener"`
+	Port      string `json:"port"`
+	Host      string `json:"host"`
+	Auth      string `json:"auth"`
+	Carver    string `json:"carver"`
+	URLPrefix string `json:"urlPrefix"`
 }
 
 // JSONConfigurationHeaders to keep all headers details for auth
@@ -57,6 +69,23 @@ type S3Configuration struct {
 	Region          string `json:"region"`
 	AccessKey       string `json:"accessKey"`
 	SecretAccessKey string `json:"secretAccesKey"`
+	// Layout of the keys of objects written by the S3 logger, empty keeps <environment>/<type>/<uuid>:<millis>.json
+	KeyTemplate string `json:"keyTemplate"`
+	// Buckets by environment for the S3 logger, environments not in the map use the bucket
+	Buckets map[string]string `json:"buckets"`
+	// Size in MB and age in seconds to write the logs buffered in one object, 0 for both writes every request
+	RotateMB      int  `json:"rotateMB"`
+	RotateSeconds int  `json:"rotateSeconds"`
+	Gzip          bool `json:"gzip"`
+}
+
+// SMTPConfiguration to hold all SMTP configuration values
+type SMTPConfiguration struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	From     string `json:"from"`
 }
 
 // OsqueryTable to show tables to query
@@ -72,6 +101,7 @@ type FlagsRequest struct {
 	Secret     string `json:"secret"`
 	SecrefFile string `json:"secretFile"`
 	CertFile   string `json:"certFile"`
+	UUID       string `json:"uuid"`
 }
 
 // CertRequest to retrieve certificate
@@ -80,11 +110,16 @@ type CertRequest FlagsRequest
 // VerifyRequest to verify nodes
 type VerifyRequest FlagsRequest
 
+// VerifyActionRemove for osctrld to run the remove script, the node is decommissioned
+const VerifyActionRemove = "remove"
+
 // VerifyResponse for verify requests from osctrld
 type VerifyResponse struct {
-	Flags          string `json:"flags"`
-	Certificate    string `json:"certificate"`
-	OsqueryVersion string `json:"osquery_version"`
+	Flags              string `json:"flags"`
+	Certificate        string `json:"certificate"`
+	CertificateVersion int    `json:"certificate_version"`
+	OsqueryVersion     string `json:"osquery_version"`
+	Action             string `json:"action,omitempty"`
 }
 
 // ScriptRequest to retrieve script
@@ -97,15 +132,138 @@ type ScriptRequest struct {
 
 // ApiDistributedQueryRequest to receive query requests
 type ApiDistributedQueryRequest struct {
-	UUID   string `json:"uuid"`
-	Query  string `json:"query"`
-	Hidden bool   `json:"hidden"`
+	UUID        string            `json:"uuid"`
+	Query       string            `json:"query"`
+	Hidden      bool              `json:"hidden"`
+	Profile     bool              `json:"profile"`
+	Sample      int               `json:"sample"`
+	Template    string            `json:"template"`
+	Variables   map[string]string `json:"variables"`
+	Description string            `json:"description"`
+	TicketID    string            `json:"ticket_id"`
+	TicketURL   string            `json:"ticket_url"`
+	Labels      map[string]string `json:"labels"`
+	// Time window to target nodes, as RFC3339 bounds
+	EnrolledAfter  string `json:"enrolled_after"`
+	EnrolledBefore string `json:"enrolled_before"`
+	SeenAfter      string `json:"seen_after"`
+	SeenBefore     string `json:"seen_before"`
+	// Filters by custom attributes to target nodes, like vuln.risk_score>7
+	Attributes []string `json:"attributes"`
+	// Confirm running it in an environment with critical notices
+	ConfirmNotices bool `json:"confirm_notices"`
+	// Name of the environment typed to confirm launches over many targets, when the policy of its category asks for it
+	ConfirmName string `json:"confirm_name"`
+	// Concurrency group to throttle it with related queries, lower priority goes first in the group
+	Group    string `json:"group"`
+	Priority int    `json:"priority"`
+	// Admins can run queries restricted by query policies with a justification, it is audited
+	Override      bool   `json:"override"`
+	Justification string `json:"justification"`
+	// Expiration in hours like 48h or days like 2d, empty for the default of the environment
+	Expiration string `json:"expiration"`
+	// Who can see the results: public, creator or team with the name of the team
+	Visibility     string `json:"visibility"`
+	VisibilityTeam string `json:"visibility_team"`
+}
+
+// ApiQueryVisibilityRequest to receive changes of who can see the results of a query
+type ApiQueryVisibilityRequest struct {
+	Visibility string `json:"visibility"`
+	Team       string `json:"team"`
+}
+
+// ApiBreakGlassRequest to receive requests of admins to see restricted results, the reason is audited
+type ApiBreakGlassRequest struct {
+	Reason string `json:"reason"`
+}
+
+// ApiTeamMemberRequest to receive changes to the members of a team
+type ApiTeamMemberRequest struct {
+	Action   string `json:"action"`
+	Team     string `json:"team"`
+	Username string `json:"username"`
+}
+
+// ApiQueryGroupRequest to receive the concurrency limits of a group of queries, zero for no limit
+type ApiQueryGroupRequest struct {
+	Name       string `json:"name"`
+	MaxPerNode int    `json:"max_per_node"`
+	MaxTotal   int    `json:"max_total"`
+}
+
+// ApiQueryPolicyRequest to receive the query policy of a subject, a username, apikey:name or * for everyone else
+type ApiQueryPolicyRequest struct {
+	Subject string   `json:"subject"`
+	Mode    string   `json:"mode"`
+	Tables  []string `json:"tables"`
+	Columns []string `json:"columns"`
+}
+
+// ApiQueryTemplateRequest to receive query template requests, the changelog is required for updates
+type ApiQueryTemplateRequest struct {
+	Name        string                     `json:"name"`
+	Description string                     `json:"description"`
+	Query       string                     `json:"query"`
+	Variables   []queries.TemplateVariable `json:"variables"`
+	Platforms   []string                   `json:"platforms"`
+	Risk        string                     `json:"risk"`
+	Team        string                     `json:"team"`
+	Approved    bool                       `json:"approved"`
+	Changelog   string                     `json:"changelog"`
 }
 
 // ApiDistributedCarveRequest to receive query requests
 type ApiDistributedCarveRequest struct {
 	UUID string `json:"uuid"`
 	Path string `json:"path"`
+	// Time window to target nodes, as RFC3339 bounds
+	EnrolledAfter  string `json:"enrolled_after"`
+	EnrolledBefore string `json:"enrolled_before"`
+	SeenAfter      string `json:"seen_after"`
+	SeenBefore     string `json:"seen_before"`
+	// Filters by custom attributes to target nodes, like vuln.risk_score>7
+	Attributes []string `json:"attributes"`
+	// Confirm running it in an environment with critical notices
+	ConfirmNotices bool `json:"confirm_notices"`
+	// Name of the environment typed to confirm launches over many targets, when the policy of its category asks for it
+	ConfirmName string `json:"confirm_name"`
+	// Justification for admins to carve a path denied in the environment
+	Justification string `json:"justification"`
+	// Who can see the carved files: public, creator or team with the name of the team
+	Visibility     string `json:"visibility"`
+	VisibilityTeam string `json:"visibility_team"`
+}
+
+// ApiCarveLimitsRequest to receive the carve limits of an environment, zero values are unlimited
+type ApiCarveLimitsRequest struct {
+	MaxBytes        int64    `json:"max_bytes"`
+	MaxNodeSessions int      `json:"max_node_sessions"`
+	MaxEnvSessions  int      `json:"max_env_sessions"`
+	DailyBytes      int64    `json:"daily_bytes"`
+	DeniedPaths     []string `json:"denied_paths"`
+}
+
+// ApiRecurringCarveRequest to receive requests for carves launched on a recurrence, for a legal hold
+type ApiRecurringCarveRequest struct {
+	CaseID string   `json:"case_id"`
+	Paths  []string `json:"paths"`
+	UUIDs  []string `json:"uuids"`
+	// Interval like 24h or cron expression in UTC like 0 2 * * *
+	Recurrence string `json:"recurrence"`
+	// End date as RFC3339, empty to run until stopped
+	EndAt string `json:"end_at"`
+}
+
+// ApiNoticeRequest to receive new notices and changes to notices, without environments for global notices
+type ApiNoticeRequest struct {
+	Message      string   `json:"message"`
+	Severity     string   `json:"severity"`
+	Environments []string `json:"environments"`
+	// Expiration as a date, RFC3339 or a duration like 48h or 3d, empty to not expire
+	ExpiresAt      string `json:"expires_at"`
+	RequireAck     bool   `json:"require_ack"`
+	ConfirmActions bool   `json:"confirm_actions"`
 }
 
 // ApiNodeGenericRequest to receive generic node requests
@@ -113,6 +271,322 @@ type ApiNodeGenericRequest struct {
 	UUID string `json:"uuid"`
 }
 
+// ApiNodeMergeRequest to receive manual merges of a duplicate node into the canonical node
+type ApiNodeMergeRequest struct {
+	Into string `json:"into"`
+	From string `json:"from"`
+}
+
+// ApiNodeMergeReviewRequest to receive reviews of merges of duplicate nodes: approve, reject or split
+type ApiNodeMergeReviewRequest struct {
+	Action string `json:"action"`
+}
+
+// ApiNodeAttributesRequest to receive changes to the custom attributes of a node, null values delete them
+type ApiNodeAttributesRequest struct {
+	Attributes map[string]interface{} `json:"attributes"`
+}
+
+// ApiBulkAttributesRequest to receive changes to the custom attributes of many nodes, by node UUID
+type ApiBulkAttributesRequest struct {
+	Nodes map[string]map[string]interface{} `json:"nodes"`
+}
+
+// ApiBulkAttributesResponse to return the result of changing the attributes of many nodes
+type ApiBulkAttributesResponse struct {
+	Updated int               `json:"updated"`
+	Errors  map[string]string `json:"errors"`
+}
+
+// ApiCertificateRequest to receive a new version of the certificate of an environment
+type ApiCertificateRequest struct {
+	Certificate string    `json:"certificate"`
+	ActivateAt  time.Time `json:"activate_at"`
+}
+
+// ApiEnrollFieldRequest to receive an enroll metadata field of an environment
+type ApiEnrollFieldRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Regex       string `json:"regex"`
+	Required    bool   `json:"required"`
+}
+
+// ApiStorageQuotaRequest to receive the storage quota of an environment in bytes, 0 removes it
+type ApiStorageQuotaRequest struct {
+	MaxBytes int64    `json:"max_bytes"`
+	Actions  []string `json:"actions"`
+}
+
+// ApiQueryExpirationRequest to receive the default expiration in hours of the queries of an environment, 0 for the global default
+type ApiQueryExpirationRequest struct {
+	Hours int `json:"hours"`
+}
+
+// ApiCategoryRequest to receive the category of an environment, empty to remove it
+type ApiCategoryRequest struct {
+	Category string `json:"category"`
+}
+
+// ApiPseudonymsRequest to receive the columns and tables pseudonymized in the logs of an environment, as patterns
+type ApiPseudonymsRequest struct {
+	Columns string `json:"columns"`
+	Tables  string `json:"tables"`
+}
+
+// ApiPseudonymLookup to return the value of a pseudonym of an environment
+type ApiPseudonymLookup struct {
+	Pseudonym string `json:"pseudonym"`
+	Column    string `json:"column"`
+	Value     string `json:"value"`
+}
+
+// ApiQuickActionRequest to receive a quick action of an environment, queries only from approved templates
+type ApiQuickActionRequest struct {
+	Name      string            `json:"name"`
+	Label     string            `json:"label"`
+	Icon      string            `json:"icon"`
+	Type      string            `json:"type"`
+	Template  string            `json:"template"`
+	Variables map[string]string `json:"variables"`
+	Path      string            `json:"path"`
+	Tag       string            `json:"tag"`
+}
+
+// ApiQuickActionRunRequest to receive a quick action to run against nodes, preview returns what would run.
+// Version, when set, must be the version that was previewed.
+type ApiQuickActionRunRequest struct {
+	UUIDs          []string `json:"uuids"`
+	Preview        bool     `json:"preview"`
+	Version        int      `json:"version"`
+	ConfirmNotices bool     `json:"confirm_notices"`
+}
+
+// ApiQuickActionRunResponse to return what a quick action ran, with the names of the queries it created
+type ApiQuickActionRunResponse struct {
+	Plan    queries.QuickActionPlan `json:"plan"`
+	Queries []string                `json:"queries"`
+}
+
+// ApiProfileRequest to receive a performance profile of an environment
+type ApiProfileRequest struct {
+	Name           string `json:"name"`
+	Priority       int    `json:"priority"`
+	Tag            string `json:"tag"`
+	Platform       string `json:"platform"`
+	HardwareModel  string `json:"hardware_model"`
+	ConfigInterval int    `json:"config_interval"`
+	LogInterval    int    `json:"log_interval"`
+	QueryInterval  int    `json:"query_interval"`
+}
+
+// ApiDenylistRequest to receive an entry of the scheduled query denylist of an environment, or the ID to lift one.
+// Hours is the time until it is lifted, 0 never expires.
+type ApiDenylistRequest struct {
+	Pack     string `json:"pack"`
+	Query    string `json:"query"`
+	Platform string `json:"platform"`
+	Tag      string `json:"tag"`
+	Reason   string `json:"reason"`
+	Hours    int    `json:"hours"`
+	ID       uint   `json:"id"`
+}
+
+// ApiRollbackRequest to receive a rollback of the configuration of an environment to a version
+type ApiRollbackRequest struct {
+	Version int    `json:"version"`
+	Note    string `json:"note"`
+}
+
+// ApiConfigImpactRequest to receive a change of one section of the configuration of an environment to preview
+type ApiConfigImpactRequest struct {
+	Section string `json:"section"`
+	Value   string `json:"value"`
+}
+
+// ApiScheduleConfigRequest to receive a change of one section of the configuration of an environment to apply later.
+// A pending change is only replaced when Replace is set.
+type ApiScheduleConfigRequest struct {
+	Section string    `json:"section"`
+	Value   string    `json:"value"`
+	ApplyAt time.Time `json:"apply_at"`
+	Note    string    `json:"note"`
+	Replace bool      `json:"replace"`
+}
+
+// ApiEnvironmentRequest to receive a new environment created from a template
+type ApiEnvironmentRequest struct {
+	Name     string `json:"name"`
+	Hostname string `json:"hostname"`
+	Template string `json:"template"`
+}
+
+// ApiPropagateRequest to receive the environments to update with the latest version of their template
+type ApiPropagateRequest struct {
+	Environments []string `json:"environments"`
+}
+
+// ApiUnlockRequest to receive the username or the IP address to unlock after failed logins
+type ApiUnlockRequest struct {
+	Username  string `json:"username"`
+	IPAddress string `json:"ip_address"`
+}
+
+// ApiWindowsEventRequest to receive the settings of a Windows event channel of an environment
+type ApiWindowsEventRequest struct {
+	Channel  string `json:"channel"`
+	Enabled  bool   `json:"enabled"`
+	Interval int    `json:"interval"`
+	MaxRows  int    `json:"max_rows"`
+}
+
+// ApiKeyRequest to receive the creation, rotation or revocation of a service key of an environment
+type ApiKeyRequest struct {
+	Name    string `json:"name"`
+	Scopes  string `json:"scopes"`
+	Days    int    `json:"days"`
+	Rotate  bool   `json:"rotate"`
+	Overlap int    `json:"overlap_hours"`
+	KeyID   string `json:"key_id"`
+}
+
+// ApiKeyResponse to return a new service key, the key is not visible again
+type ApiKeyResponse struct {
+	Name      string    `json:"name"`
+	KeyID     string    `json:"key_id"`
+	Scopes    string    `json:"scopes"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Key       string    `json:"key"`
+}
+
+// ApiDeadLetterRequest to receive which dead letters of failed log deliveries to replay or purge
+type ApiDeadLetterRequest struct {
+	IDs         []uint `json:"ids"`
+	Backend     string `json:"backend"`
+	Reason      string `json:"reason"`
+	Environment string `json:"environment"`
+	OlderThan   string `json:"older_than"`
+	Target      string `json:"target"`
+	Limit       int    `json:"limit"`
+	All         bool   `json:"all"`
+}
+
+// ApiScheduleOverrideRequest to receive a temporary interval for one scheduled query of a node
+type ApiScheduleOverrideRequest struct {
+	Query     string    `json:"query"`
+	Interval  int       `json:"interval"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ApiNodePauseRequest to receive requests to pause a node, with the reason and when the pause expires
+type ApiNodePauseRequest struct {
+	Reason string `json:"reason"`
+	// Expiration as a date, RFC3339 or a duration like 48h or 3d, empty to not expire
+	ExpiresAt string `json:"expires_at"`
+}
+
+// ApiWebhookRequest to receive requests to create a webhook endpoint, subscribed to all events if empty
+type ApiWebhookRequest struct {
+	Name   string   `json:"name"`
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+// ApiPackSourceRequest to receive the Git repository that the packs of an environment are synced from,
+// with the credential as env:OSCTRL_PACK_NAME or file:name in the credentials directory
+type ApiPackSourceRequest struct {
+	URL        string `json:"url"`
+	Branch     string `json:"branch"`
+	PathGlob   string `json:"path_glob"`
+	AuthKind   string `json:"auth_kind"`
+	Credential string `json:"credential"`
+	Interval   int    `json:"interval"`
+	Enabled    bool   `json:"enabled"`
+}
+
+// ApiPackResolveRequest to receive if the repository or the manual version wins for a synced pack
+type ApiPackResolveRequest struct {
+	Pack       string `json:"pack"`
+	Resolution string `json:"resolution"`
+}
+
+// ApiWebhookSecretResponse to be returned when the secret of a webhook endpoint is generated, it is only shown then
+type ApiWebhookSecretResponse struct {
+	Name   string `json:"name"`
+	Secret string `json:"secret"`
+}
+
+// ApiStatusTokenRequest to receive requests to generate a status token for a node, with the hours it is valid
+type ApiStatusTokenRequest struct {
+	Hours int64 `json:"hours"`
+}
+
+// ApiStatusTokenResponse to return a status token for a node and the path to show its status in osctrl-admin
+type ApiStatusTokenResponse struct {
+	Token     string    `json:"token"`
+	Path      string    `json:"path"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ApiResponseConfigRequest to receive the response actions configuration of an environment, Reset closes the circuit breaker
+type ApiResponseConfigRequest struct {
+	Enabled    bool   `json:"enabled"`
+	Allowed    string `json:"allowed"`
+	Cooldown   int    `json:"cooldown"`
+	MaxPerHour int    `json:"max_per_hour"`
+	Reset      bool   `json:"reset"`
+}
+
+// ApiResponseTriggerRequest to receive a response trigger of an environment, variables render {{field}} of the event
+type ApiResponseTriggerRequest struct {
+	Name      string            `json:"name"`
+	Query     string            `json:"query"`
+	Column    string            `json:"column"`
+	Value     string            `json:"value"`
+	Kind      string            `json:"kind"`
+	Template  string            `json:"template"`
+	Variables map[string]string `json:"variables"`
+	Path      string            `json:"path"`
+	Enabled   bool              `json:"enabled"`
+}
+
+// ApiResponseAlertRequest to receive external alerts that fire a response trigger for one node
+type ApiResponseAlertRequest struct {
+	Trigger string            `json:"trigger"`
+	UUID    string            `json:"uuid"`
+	Event   map[string]string `json:"event"`
+}
+
+// ApiMuteRequest to receive mutes of notifications for a node, or for the whole environment without UUID.
+// Mutes expire after Hours, unless they are permanent, and ID is the mute to lift.
+type ApiMuteRequest struct {
+	Kind      string `json:"kind"`
+	Rule      string `json:"rule"`
+	UUID      string `json:"uuid"`
+	Reason    string `json:"reason"`
+	Hours     int    `json:"hours"`
+	Permanent bool   `json:"permanent"`
+	Notify    bool   `json:"notify"`
+	ID        uint   `json:"id"`
+}
+
+// ApiReportRequest to receive report generation requests, all environments if Environment is empty
+type ApiReportRequest struct {
+	Environment string `json:"environment"`
+	// Category of the environments of a fleet wide report, empty for all environments
+	Category string `json:"category"`
+	Period   string `json:"period"`
+	Format   string `json:"format"`
+}
+
+// ApiShareRequest to receive requests of sharing exports of queries, the default deny-list is used if DenyColumns is null
+type ApiShareRequest struct {
+	Queries     []string `json:"queries"`
+	Format      string   `json:"format"`
+	DropIPs     bool     `json:"drop_ips"`
+	DenyColumns []string `json:"deny_columns"`
+}
+
 // ApiLoginRequest to receive login requests
 type ApiLoginRequest struct {
 	Username string `json:"username"`
@@ -129,6 +603,12 @@ type ApiQueriesResponse struct {
 	Name string `json:"query_name"`
 }
 
+// ApiTrashRequest to receive requests to restore an item from the trash, by kind and name
+type ApiTrashRequest struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
+
 // ApiGenericResponse to be returned to API requests for anything
 type ApiGenericResponse struct {
 	Message string `json:"message"`
@@ -138,3 +618,21 @@ type ApiGenericResponse struct {
 type ApiLoginResponse struct {
 	Token string `json:"token"`
 }
+
+// ApiActivityEntry to be returned to API requests for the activity of an environment
+type ApiActivityEntry struct {
+	ID        uint      `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Kind      string    `json:"kind"`
+	Actor     string    `json:"actor"`
+	Summary   string    `json:"summary"`
+	Changed   bool      `json:"changed"`
+}
+
+// ApiActivityResponse to be returned to API requests for one page of the activity of an environment
+type ApiActivityResponse struct {
+	Total   int64              `json:"total"`
+	Page    int                `json:"page"`
+	Limit   int                `json:"limit"`
+	Entries []ApiActivityEntry `json:"entries"`
+}
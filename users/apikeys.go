@@ -0,0 +1,326 @@
+package users
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Scopes of service keys, each one grants access to a group of API endpoints of the environment of the key
+const (
+	ScopeNodesRead         = "nodes:read"
+	ScopeNodesWrite        = "nodes:write"
+	ScopeQueriesRead       = "queries:read"
+	ScopeQueriesWrite      = "queries:write"
+	ScopeCarvesRead        = "carves:read"
+	ScopeCarvesWrite       = "carves:write"
+	ScopeResultsRead       = "results:read"
+	ScopeEnvironmentsRead  = "environments:read"
+	ScopeEnvironmentsWrite = "environments:write"
+	ScopeActionsRead       = "actions:read"
+	ScopeActionsWrite      = "actions:write"
+	ScopeAttributesWrite   = "attributes:write"
+	// ScopeAdmin grants the admin level in the environment of the key, it is never implied by the other scopes
+	ScopeAdmin = "environment:admin"
+)
+
+// APIKeyScopes to list all the scopes of service keys
+var APIKeyScopes = []string{
+	ScopeNodesRead,
+	ScopeNodesWrite,
+	ScopeQueriesRead,
+	ScopeQueriesWrite,
+	ScopeCarvesRead,
+	ScopeCarvesWrite,
+	ScopeResultsRead,
+	ScopeEnvironmentsRead,
+	ScopeEnvironmentsWrite,
+	ScopeActionsRead,
+	ScopeActionsWrite,
+	ScopeAttributesWrite,
+	ScopeAdmin,
+}
+
+// Namespaced scopes can be limited to one namespace with a suffix, like attributes:write:cmdb
+var namespacedScopes = []string{ScopeAttributesWrite}
+
+// Valid namespaces of namespaced scopes
+var scopeNamespaceRe = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// Helper to split a namespaced scope in its scope and namespace
+func splitNamespacedScope(scope string) (string, string, bool) {
+	for _, ns := range namespacedScopes {
+		if strings.HasPrefix(scope, ns+":") {
+			namespace := strings.TrimPrefix(scope, ns+":")
+			return ns, namespace, scopeNamespaceRe.MatchString(namespace)
+		}
+	}
+	return "", "", false
+}
+
+const (
+	// APIKeyPrefix to identify service keys from JWT tokens
+	APIKeyPrefix = "osk_"
+	// APIKeyActor to prefix the name of service keys where users are recorded, like the activity
+	APIKeyActor = "apikey:"
+	// DefaultAPIKeyDays for the expiration of new service keys
+	DefaultAPIKeyDays = 90
+	// DefaultAPIKeyOverlap for how long the old key keeps working after a rotation
+	DefaultAPIKeyOverlap = 24 * time.Hour
+	// Length of the random identifier of keys, stored in clear to find them
+	apiKeyIDLength = 8
+	// Length of the random secret of keys, only the hash is stored
+	apiKeySecretLength = 32
+)
+
+// APIKey to hold the service credentials of one environment
+type APIKey struct {
+	gorm.Model
+	Name          string `gorm:"index"`
+	Environment   string `gorm:"index"`
+	KeyID         string `gorm:"uniqueIndex"`
+	KeyHash       string `json:"-"`
+	Scopes        string
+	ExpiresAt     time.Time
+	Revoked       bool
+	RevokedAt     time.Time
+	Creator       string
+	LastUsedAt    time.Time
+	LastIPAddress string
+	Requests      int64
+}
+
+// Actor to get the name used for the key in the activity and logs
+func (k APIKey) Actor() string {
+	return APIKeyActor + k.Name
+}
+
+// ScopeList to get the scopes of the key as slice
+func (k APIKey) ScopeList() []string {
+	if k.Scopes == "" {
+		return []string{}
+	}
+	return strings.Split(k.Scopes, ",")
+}
+
+// HasScope to check if the key was granted one scope, namespaced scopes grant the scope for their namespace
+func (k APIKey) HasScope(scope string) bool {
+	for _, s := range k.ScopeList() {
+		if s == scope {
+			return true
+		}
+		if base, _, ok := splitNamespacedScope(s); ok && base == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// HasNamespace to check if the key was granted a namespaced scope for one namespace, the scope without
+// namespace grants all of them
+func (k APIKey) HasNamespace(scope, namespace string) bool {
+	for _, s := range k.ScopeList() {
+		if s == scope || s == scope+":"+namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// Expired to check if the key can not be used anymore at the given time
+func (k APIKey) Expired(now time.Time) bool {
+	return !k.ExpiresAt.IsZero() && now.After(k.ExpiresAt)
+}
+
+// Active to check if the key is not revoked and not expired
+func (k APIKey) Active(now time.Time) bool {
+	return !k.Revoked && !k.Expired(now)
+}
+
+// APIKeyExpiration to get when a key expires from its days, 0 uses the default and negative never expires
+func APIKeyExpiration(days int, now time.Time) time.Time {
+	if days < 0 {
+		return time.Time{}
+	}
+	if days == 0 {
+		days = DefaultAPIKeyDays
+	}
+	return now.Add(time.Duration(days) * 24 * time.Hour)
+}
+
+// IsAPIKey to check if a token is a service key
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
+
+// ParseScopes to validate a comma separated list of scopes, returned sorted and without duplicates
+func ParseScopes(scopes string) ([]string, error) {
+	valid := make(map[string]bool)
+	for _, s := range APIKeyScopes {
+		valid[s] = true
+	}
+	seen := make(map[string]bool)
+	var res []string
+	for _, s := range strings.Split(scopes, ",") {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if s == "" || seen[s] {
+			continue
+		}
+		if _, _, ok := splitNamespacedScope(s); !valid[s] && !ok {
+			return nil, fmt.Errorf("invalid scope %s", s)
+		}
+		seen[s] = true
+		res = append(res, s)
+	}
+	if len(res) == 0 {
+		return nil, fmt.Errorf("at least one scope is required")
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// Helper to hash the secret of service keys, they have enough entropy to not need a slow hash
+func hashAPIKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Helper to split a service key in its identifier and secret
+func splitAPIKey(token string) (string, bool) {
+	parts := strings.SplitN(strings.TrimPrefix(token, APIKeyPrefix), "_", 2)
+	if !IsAPIKey(token) || len(parts) != 2 || len(parts[0]) != 2*apiKeyIDLength || parts[1] == "" {
+		return "", false
+	}
+	return parts[0], true
+}
+
+// Helper to generate random hex strings for service keys
+func randomHex(length int) (string, error) {
+	b := make([]byte, length)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// NewAPIKey to create a service key for an environment, the returned token is the only time the key is visible
+func (m *UserManager) NewAPIKey(name, environment string, scopes []string, expires time.Time, creator string) (APIKey, string, error) {
+	if name == "" {
+		return APIKey{}, "", fmt.Errorf("name can not be empty")
+	}
+	keyID, err := randomHex(apiKeyIDLength)
+	if err != nil {
+		return APIKey{}, "", fmt.Errorf("randomHex %v", err)
+	}
+	secret, err := randomHex(apiKeySecretLength)
+	if err != nil {
+		return APIKey{}, "", fmt.Errorf("randomHex %v", err)
+	}
+	token := APIKeyPrefix + keyID + "_" + secret
+	key := APIKey{
+		Name:        name,
+		Environment: environment,
+		KeyID:       keyID,
+		KeyHash:     hashAPIKey(token),
+		Scopes:      strings.Join(scopes, ","),
+		ExpiresAt:   expires,
+		Creator:     creator,
+	}
+	if err := m.DB.Create(&key).Error; err != nil {
+		return APIKey{}, "", fmt.Errorf("Create APIKey %v", err)
+	}
+	return key, token, nil
+}
+
+// RotateAPIKey to issue a new key with the name and scopes of the active keys with that name, which keep
+// working until the overlap ends so clients can switch to the new key
+func (m *UserManager) RotateAPIKey(name, environment string, expires time.Time, overlap time.Duration, creator string) (APIKey, string, error) {
+	now := time.Now()
+	var current []APIKey
+	if err := m.DB.Where("name = ? AND environment = ? AND revoked = ?", name, environment, false).Order("id").Find(&current).Error; err != nil {
+		return APIKey{}, "", fmt.Errorf("Find APIKey %v", err)
+	}
+	var old []APIKey
+	for _, k := range current {
+		if k.Active(now) {
+			old = append(old, k)
+		}
+	}
+	if len(old) == 0 {
+		return APIKey{}, "", fmt.Errorf("no active key %s", name)
+	}
+	key, token, err := m.NewAPIKey(name, environment, old[len(old)-1].ScopeList(), expires, creator)
+	if err != nil {
+		return key, token, err
+	}
+	end := now.Add(overlap)
+	for _, k := range old {
+		if k.ExpiresAt.IsZero() || k.ExpiresAt.After(end) {
+			if err := m.DB.Model(&k).Update("expires_at", end).Error; err != nil {
+				return key, token, fmt.Errorf("Update APIKey %v", err)
+			}
+		}
+	}
+	return key, token, nil
+}
+
+// RevokeAPIKey to revoke one key of an environment by its identifier
+func (m *UserManager) RevokeAPIKey(keyID, environment string) error {
+	res := m.DB.Model(&APIKey{}).Where("key_id = ? AND environment = ?", keyID, environment).Updates(map[string]interface{}{
+		"revoked":    true,
+		"revoked_at": time.Now(),
+	})
+	if res.Error != nil {
+		return fmt.Errorf("Updates APIKey %v", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("key %s not found", keyID)
+	}
+	return nil
+}
+
+// APIKeys to get all the keys of an environment, newest first
+func (m *UserManager) APIKeys(environment string) ([]APIKey, error) {
+	var keys []APIKey
+	if err := m.DB.Where("environment = ?", environment).Order("id DESC").Find(&keys).Error; err != nil {
+		return keys, fmt.Errorf("Find APIKey %v", err)
+	}
+	return keys, nil
+}
+
+// CheckAPIKey to verify a service key, returns the key if it is active
+func (m *UserManager) CheckAPIKey(token string) (APIKey, bool) {
+	var key APIKey
+	keyID, ok := splitAPIKey(token)
+	if !ok {
+		return key, false
+	}
+	if err := m.DB.Where("key_id = ?", keyID).First(&key).Error; err != nil {
+		return key, false
+	}
+	if subtle.ConstantTimeCompare([]byte(key.KeyHash), []byte(hashAPIKey(token))) != 1 {
+		return APIKey{}, false
+	}
+	return key, key.Active(time.Now())
+}
+
+// UpdateAPIKeyUsage to record one request with a service key
+func (m *UserManager) UpdateAPIKeyUsage(key APIKey, ipaddress string) error {
+	err := m.DB.Model(&key).Updates(map[string]interface{}{
+		"last_used_at":    time.Now(),
+		"last_ip_address": ipaddress,
+		"requests":        gorm.Expr("requests + ?", 1),
+	}).Error
+	if err != nil {
+		return fmt.Errorf("Updates APIKey %v", err)
+	}
+	return nil
+}
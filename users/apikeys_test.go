@@ -0,0 +1,61 @@
+package users
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseScopes(t *testing.T) {
+	scopes, err := ParseScopes("queries:write, nodes:read,NODES:READ")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{ScopeNodesRead, ScopeQueriesWrite}, scopes)
+	_, err = ParseScopes("nodes:read,users:write")
+	assert.Error(t, err)
+	_, err = ParseScopes(" , ")
+	assert.Error(t, err)
+	scopes, err = ParseScopes("attributes:write:cmdb,nodes:read")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"attributes:write:cmdb", ScopeNodesRead}, scopes)
+	_, err = ParseScopes("attributes:write:cmdb.owner")
+	assert.Error(t, err)
+	_, err = ParseScopes("nodes:read:cmdb")
+	assert.Error(t, err)
+}
+
+func TestAPIKeyNamespaces(t *testing.T) {
+	key := APIKey{Name: "cmdb-sync", Scopes: "attributes:write:cmdb,nodes:read"}
+	assert.True(t, key.HasScope(ScopeAttributesWrite))
+	assert.True(t, key.HasNamespace(ScopeAttributesWrite, "cmdb"))
+	assert.False(t, key.HasNamespace(ScopeAttributesWrite, "vuln"))
+	key.Scopes = "attributes:write"
+	assert.True(t, key.HasNamespace(ScopeAttributesWrite, "vuln"))
+}
+
+func TestAPIKeyActive(t *testing.T) {
+	now := time.Now()
+	key := APIKey{Name: "provisioner", Scopes: "nodes:read,queries:write"}
+	assert.True(t, key.Active(now))
+	assert.True(t, key.HasScope(ScopeQueriesWrite))
+	assert.False(t, key.HasScope(ScopeQueriesRead))
+	assert.Equal(t, "apikey:provisioner", key.Actor())
+	key.ExpiresAt = now.Add(-time.Minute)
+	assert.False(t, key.Active(now))
+	key.ExpiresAt = now.Add(time.Minute)
+	key.Revoked = true
+	assert.False(t, key.Active(now))
+}
+
+func TestSplitAPIKey(t *testing.T) {
+	keyID, ok := splitAPIKey("osk_0123456789abcdef_secret")
+	assert.True(t, ok)
+	assert.Equal(t, "0123456789abcdef", keyID)
+	_, ok = splitAPIKey("osk_0123_secret")
+	assert.False(t, ok)
+	_, ok = splitAPIKey("osk_0123456789abcdef_")
+	assert.False(t, ok)
+	_, ok = splitAPIKey("eyJhbGciOiJIUzI1NiJ9.e30.sig")
+	assert.False(t, ok)
+	assert.NotEqual(t, hashAPIKey("osk_a_b"), hashAPIKey("osk_a_c"))
+}
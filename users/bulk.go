@@ -0,0 +1,235 @@
+package users
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/jmpsec/osctrl/environments"
+	"gorm.io/gorm"
+)
+
+// Names of the access levels, as used by the CLI and the API
+const (
+	LevelUser  = "user"
+	LevelQuery = "query"
+	LevelCarve = "carve"
+	LevelAdmin = "admin"
+)
+
+// PermissionChange to keep track of the bulk changes of permissions for a user
+type PermissionChange struct {
+	gorm.Model
+	Username  string `gorm:"index"`
+	ChangedBy string
+	Before    string
+	After     string
+}
+
+// AccessChange to return the access of a user before and after a bulk change
+type AccessChange struct {
+	Before UserAccess `json:"before"`
+	After  UserAccess `json:"after"`
+}
+
+// ResolveEnvs to get the UUIDs of environments by name or UUID, all of them must exist
+func ResolveEnvs(envs environments.MapEnvironments, identifiers []string) ([]string, error) {
+	var uuids []string
+	var missing []string
+	for _, id := range identifiers {
+		env, ok := envs[id]
+		if !ok {
+			missing = append(missing, id)
+			continue
+		}
+		uuids = append(uuids, env.UUID)
+	}
+	if len(missing) > 0 {
+		return uuids, fmt.Errorf("environments not found: %s", strings.Join(missing, ", "))
+	}
+	return uuids, nil
+}
+
+// ResolveAccess to key access by environment UUID, when it uses names. All environments must exist.
+func ResolveAccess(envs environments.MapEnvironments, access UserAccess) (UserAccess, error) {
+	resolved := make(UserAccess)
+	var missing []string
+	for id, a := range access {
+		env, ok := envs[id]
+		if !ok {
+			missing = append(missing, id)
+			continue
+		}
+		resolved[env.UUID] = a
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return resolved, fmt.Errorf("environments not found: %s", strings.Join(missing, ", "))
+	}
+	return resolved, nil
+}
+
+// LevelAccess to get the access for a level name, query and carve also grant user access
+func LevelAccess(level string) (EnvAccess, error) {
+	switch strings.ToLower(level) {
+	case LevelUser:
+		return GenEnvAccess(false, false, false, true), nil
+	case LevelQuery:
+		return GenEnvAccess(false, false, true, true), nil
+	case LevelCarve:
+		return GenEnvAccess(false, true, false, true), nil
+	case LevelAdmin:
+		return GenEnvAccess(true, false, false, false), nil
+	}
+	return EnvAccess{}, fmt.Errorf("invalid level %s", level)
+}
+
+// MergeAccess to add the access in extra to the current access, nothing is revoked
+func MergeAccess(current, extra UserAccess) UserAccess {
+	merged := make(UserAccess)
+	for env, a := range current {
+		merged[env] = a
+	}
+	for env, a := range extra {
+		m := merged[env]
+		merged[env] = GenEnvAccess(m.Admin || a.Admin, m.Carve || a.Carve, m.Query || a.Query, m.User || a.User)
+	}
+	return merged
+}
+
+// RemoveAccess to remove all the access to the given environments
+func RemoveAccess(current UserAccess, envs []string) UserAccess {
+	removed := make(UserAccess)
+	for env, a := range current {
+		removed[env] = a
+	}
+	for _, env := range envs {
+		delete(removed, env)
+	}
+	return removed
+}
+
+// Helper to serialize access for the audit trail, map keys are sorted so it can be compared
+func accessString(access UserAccess) string {
+	res, err := json.Marshal(access)
+	if err != nil {
+		return ""
+	}
+	return string(res)
+}
+
+// SetAccess to replace all the permissions of a user with the provided access, in one transaction.
+// Environments without any access are dropped. The change is recorded with the access before and after.
+func (m *UserManager) SetAccess(username, granted string, access UserAccess) (UserAccess, UserAccess, error) {
+	after := make(UserAccess)
+	before, err := m.GetAccess(username)
+	if err != nil {
+		return before, after, err
+	}
+	for env, a := range access {
+		if a != (EnvAccess{}) {
+			after[env] = GenEnvAccess(a.Admin, a.Carve, a.Query, a.User)
+		}
+	}
+	err = m.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("username = ?", username).Delete(&UserPermission{}).Error; err != nil {
+			return fmt.Errorf("Delete %v", err)
+		}
+		if perms := m.GenPermissions(username, granted, after); len(perms) > 0 {
+			if err := tx.Create(&perms).Error; err != nil {
+				return fmt.Errorf("Create %v", err)
+			}
+		}
+		change := PermissionChange{
+			Username:  username,
+			ChangedBy: granted,
+			Before:    accessString(before),
+			After:     accessString(after),
+		}
+		if err := tx.Create(&change).Error; err != nil {
+			return fmt.Errorf("Create PermissionChange %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return before, after, err
+	}
+	log.Printf("permissions for %s changed by %s from %s to %s", username, granted, accessString(before), accessString(after))
+	return before, after, nil
+}
+
+// GetPermissionChanges to get the recorded bulk changes of permissions for a user
+func (m *UserManager) GetPermissionChanges(username string) ([]PermissionChange, error) {
+	var changes []PermissionChange
+	if err := m.DB.Where("username = ?", username).Order("created_at desc").Find(&changes).Error; err != nil {
+		return changes, err
+	}
+	return changes, nil
+}
+
+// AccessLines to describe the access to one environment, one level per line so changes can be compared
+func AccessLines(a EnvAccess) string {
+	return fmt.Sprintf("user: %t\nquery: %t\ncarve: %t\nadmin: %t", a.User, a.Query, a.Carve, a.Admin)
+}
+
+// ChangedEnvs to get the sorted UUIDs of environments with different access before and after a change
+func ChangedEnvs(before, after UserAccess) []string {
+	var changed []string
+	for env, a := range after {
+		if !SameAccess(a, before[env]) {
+			changed = append(changed, env)
+		}
+	}
+	for env, b := range before {
+		if _, ok := after[env]; !ok && b != (EnvAccess{}) {
+			changed = append(changed, env)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// RecordAccessChanges to record the changes of access of a user in the activity of each environment
+func RecordAccessChanges(envs *environments.Environment, username, actor string, before, after UserAccess) error {
+	for _, uuid := range ChangedEnvs(before, after) {
+		env, err := envs.Get(uuid)
+		if err != nil {
+			return fmt.Errorf("Get %s %v", uuid, err)
+		}
+		// Each permission granted or revoked is recorded on its own, so carve grants can be audited apart from queries
+		for _, change := range AccessGrants(before[uuid], after[uuid]) {
+			summary := fmt.Sprintf("%s for %s", change, username)
+			if err := envs.RecordActivity(env.ID, environments.ActivityPermissions, actor, summary, AccessLines(before[uuid]), AccessLines(after[uuid])); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// AccessGrants to describe each permission granted or revoked between two accesses to one environment
+func AccessGrants(before, after EnvAccess) []string {
+	levels := []struct {
+		name          string
+		before, after bool
+	}{
+		{LevelUser, before.User, after.User},
+		{LevelQuery, before.Query, after.Query},
+		{LevelCarve, before.Carve, after.Carve},
+		{LevelAdmin, before.Admin, after.Admin},
+	}
+	var changes []string
+	for _, l := range levels {
+		if l.before == l.after {
+			continue
+		}
+		if l.after {
+			changes = append(changes, l.name+" permission granted")
+		} else {
+			changes = append(changes, l.name+" permission revoked")
+		}
+	}
+	return changes
+}
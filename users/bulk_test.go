@@ -0,0 +1,118 @@
+package users
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmpsec/osctrl/environments"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLevelAccess(t *testing.T) {
+	a, err := LevelAccess("user")
+	assert.NoError(t, err)
+	assert.Equal(t, EnvAccess{User: true}, a)
+	a, err = LevelAccess("query")
+	assert.NoError(t, err)
+	assert.Equal(t, EnvAccess{User: true, Query: true}, a)
+	a, err = LevelAccess("Admin")
+	assert.NoError(t, err)
+	assert.Equal(t, EnvAccess{User: true, Query: true, Carve: true, Admin: true}, a)
+	_, err = LevelAccess("root")
+	assert.Error(t, err)
+}
+
+func TestMergeRemoveAccess(t *testing.T) {
+	current := UserAccess{
+		"dev":     EnvAccess{User: true, Query: true},
+		"staging": EnvAccess{User: true},
+	}
+	merged := MergeAccess(current, UserAccess{
+		"dev":  EnvAccess{User: true, Carve: true},
+		"prod": EnvAccess{User: true},
+	})
+	assert.Equal(t, EnvAccess{User: true, Query: true, Carve: true}, merged["dev"])
+	assert.Equal(t, EnvAccess{User: true}, merged["staging"])
+	assert.Equal(t, EnvAccess{User: true}, merged["prod"])
+	// Current access is not modified
+	assert.Equal(t, 2, len(current))
+	removed := RemoveAccess(merged, []string{"dev", "missing"})
+	assert.Equal(t, 2, len(removed))
+	_, ok := removed["dev"]
+	assert.False(t, ok)
+	assert.Equal(t, 3, len(merged))
+}
+
+func TestChangedEnvs(t *testing.T) {
+	before := UserAccess{
+		"dev":     EnvAccess{User: true, Query: true},
+		"staging": EnvAccess{User: true},
+		"prod":    EnvAccess{User: true},
+	}
+	after := UserAccess{
+		"dev":  EnvAccess{User: true, Query: true},
+		"prod": EnvAccess{User: true, Admin: true},
+		"qa":   EnvAccess{User: true},
+	}
+	assert.Equal(t, []string{"prod", "qa", "staging"}, ChangedEnvs(before, after))
+	assert.Nil(t, ChangedEnvs(before, before))
+	assert.Equal(t, "user: true\nquery: false\ncarve: false\nadmin: true", AccessLines(after["prod"]))
+}
+
+func TestResolveAccess(t *testing.T) {
+	envs := environments.MapEnvironments{
+		"dev":      environments.TLSEnvironment{Name: "dev", UUID: "uuid-dev"},
+		"uuid-dev": environments.TLSEnvironment{Name: "dev", UUID: "uuid-dev"},
+	}
+	resolved, err := ResolveAccess(envs, UserAccess{"dev": EnvAccess{User: true}})
+	assert.NoError(t, err)
+	assert.Equal(t, UserAccess{"uuid-dev": EnvAccess{User: true}}, resolved)
+	_, err = ResolveAccess(envs, UserAccess{"dev": EnvAccess{User: true}, "prod": EnvAccess{User: true}, "lab": EnvAccess{}})
+	assert.EqualError(t, err, "environments not found: lab, prod")
+	uuids, err := ResolveEnvs(envs, []string{"uuid-dev"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"uuid-dev"}, uuids)
+	_, err = ResolveEnvs(envs, []string{"dev", "prod"})
+	assert.Error(t, err)
+}
+
+func TestSetAccessRollback(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db, got error: %v", err)
+	}
+	defer mockDB.Close()
+	_postgres, err := gorm.Open(postgres.New(postgres.Config{Conn: mockDB}), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("unable to create new postgres database: %v", err)
+	}
+	manager := &UserManager{DB: _postgres}
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "admin_users"`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(`SELECT \* FROM "user_permissions"`).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "username", "access_type", "access_value", "environment"}).AddRow(1, "alice", int(UserLevel), true, "dev"))
+	// Audit record fails, nothing must be applied
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM "user_permissions"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO "user_permissions"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2).AddRow(3).AddRow(4).AddRow(5))
+	mock.ExpectQuery(`INSERT INTO "permission_changes"`).WillReturnError(errors.New("audit failed"))
+	mock.ExpectRollback()
+	before, after, err := manager.SetAccess("alice", "admin", UserAccess{
+		"prod":    EnvAccess{User: true},
+		"staging": EnvAccess{},
+	})
+	assert.Error(t, err)
+	assert.Equal(t, UserAccess{"dev": EnvAccess{User: true}}, before)
+	assert.Equal(t, UserAccess{"prod": EnvAccess{User: true}}, after)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAccessGrants(t *testing.T) {
+	before := EnvAccess{User: true, Query: true}
+	assert.Equal(t, []string{"carve permission granted"}, AccessGrants(before, EnvAccess{User: true, Query: true, Carve: true}))
+	assert.Equal(t, []string{"query permission revoked", "carve permission granted"}, AccessGrants(before, EnvAccess{User: true, Carve: true}))
+	assert.Nil(t, AccessGrants(before, before))
+}
@@ -0,0 +1,371 @@
+package users
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// LoginUser for login attempts counted by username
+	LoginUser string = "user"
+	// LoginIP for login attempts counted by source IP address
+	LoginIP string = "ip"
+	// LoginToken for API requests counted by token
+	LoginToken string = "token"
+	// DefaultLoginFailures is the default number of failures of a username or token before it is locked out
+	DefaultLoginFailures int64 = 10
+	// DefaultLoginLockoutMinutes is the default duration of lockouts in minutes
+	DefaultLoginLockoutMinutes int64 = 15
+	// Failures of an IP address allowed for each failure of a username, since many users can share an IP address
+	loginIPFactor int64 = 5
+	// Failures without delay, so typos do not slow down legitimate users
+	loginFreeFailures int64 = 3
+	// First delay after the free failures, doubled with each failure
+	loginBaseDelay = time.Second
+	// Maximum delay between attempts
+	loginMaxDelay = time.Minute
+)
+
+// ErrLoginThrottled is returned for attempts during a delay or a lockout. The message is the same
+// for existing and unknown usernames, so it does not reveal which usernames exist.
+var ErrLoginThrottled = errors.New("too many login attempts, try again later")
+
+// LoginSubject to identify what login attempts are counted by
+type LoginSubject struct {
+	Kind string
+	ID   string
+}
+
+// Key to get the key of a subject to store its counters
+func (s LoginSubject) Key() string {
+	return s.Kind + ":" + strings.ToLower(s.ID)
+}
+
+// LoginSubjects to get the subjects counted for a login with username and password
+func LoginSubjects(username, ipaddress string) []LoginSubject {
+	return []LoginSubject{{Kind: LoginUser, ID: username}, {Kind: LoginIP, ID: ipaddress}}
+}
+
+// TokenSubjects to get the subjects counted for an API request with a token, tokens are only kept hashed
+func TokenSubjects(token, ipaddress string) []LoginSubject {
+	h := sha256.Sum256([]byte(token))
+	return []LoginSubject{{Kind: LoginToken, ID: hex.EncodeToString(h[:8])}, {Kind: LoginIP, ID: ipaddress}}
+}
+
+// LoginLockout to keep track of the lockouts of usernames, IP addresses and tokens
+type LoginLockout struct {
+	gorm.Model
+	Kind        string `gorm:"index"`
+	Subject     string `gorm:"index"`
+	Failures    int64
+	LockedUntil time.Time
+	Unlocked    bool
+	UnlockedAt  time.Time
+	UnlockedBy  string
+}
+
+// Active to check if a lockout is still preventing logins
+func (l LoginLockout) Active(now time.Time) bool {
+	return !l.Unlocked && now.Before(l.LockedUntil)
+}
+
+// LoginPolicy to define the delays and the lockouts for failed login attempts
+type LoginPolicy struct {
+	MaxFailures int64
+	Lockout     time.Duration
+}
+
+// NewLoginPolicy to prepare a policy from settings values, 0 uses the defaults
+func NewLoginPolicy(maxFailures, lockoutMinutes int64) LoginPolicy {
+	if maxFailures <= 0 {
+		maxFailures = DefaultLoginFailures
+	}
+	if lockoutMinutes <= 0 {
+		lockoutMinutes = DefaultLoginLockoutMinutes
+	}
+	return LoginPolicy{MaxFailures: maxFailures, Lockout: time.Duration(lockoutMinutes) * time.Minute}
+}
+
+// Limit to get the failures of a kind of subject that trigger a lockout
+func (p LoginPolicy) Limit(kind string) int64 {
+	if kind == LoginIP {
+		return p.MaxFailures * loginIPFactor
+	}
+	return p.MaxFailures
+}
+
+// Delay to get the time to wait before another attempt after a number of consecutive failures.
+// The first failures have no delay, after them the delay doubles with each failure.
+func (p LoginPolicy) Delay(kind string, failures int64) time.Duration {
+	if kind == LoginIP {
+		failures /= loginIPFactor
+	}
+	if failures < loginFreeFailures {
+		return 0
+	}
+	delay := loginBaseDelay
+	for i := loginFreeFailures; i < failures && delay < loginMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > loginMaxDelay {
+		delay = loginMaxDelay
+	}
+	return delay
+}
+
+// LoginBlock to hold the delay and the lockout of a subject
+type LoginBlock struct {
+	DelayUntil time.Time
+	LockoutID  uint
+}
+
+// LoginCounters to keep the failed attempts of subjects with expiration, shared by all the instances of a service
+type LoginCounters interface {
+	// LoginBlocks to get the delay and the lockout of each key, in the same order
+	LoginBlocks(keys []string) ([]LoginBlock, error)
+	// ReserveLogin to count an attempt of a key and set the delay for its next attempt atomically,
+	// returns 0 attempts and the block if the key is delayed or locked out. Counters expire after the window.
+	ReserveLogin(key string, window time.Duration, delay func(attempts int64) time.Duration) (int64, LoginBlock, error)
+	// SetLoginLockout to keep the lockout of a key for a duration, clearing its failures
+	SetLoginLockout(key string, id uint, duration time.Duration) error
+	// ResetLogin to clear the failures, the delay and the lockout of a key
+	ResetLogin(key string) error
+}
+
+// LoginLockouts to record lockouts, so they can be audited and unlocked by administrators
+type LoginLockouts interface {
+	CreateLockout(lockout *LoginLockout) error
+	GetLockout(id uint) (LoginLockout, error)
+}
+
+// LoginThrottle to delay and lock out failed login attempts
+type LoginThrottle struct {
+	Counters LoginCounters
+	Lockouts LoginLockouts
+	// Policy to get the current policy, so changes in settings are used
+	Policy func() LoginPolicy
+	// Notify is called with each new lockout
+	Notify func(lockout LoginLockout)
+	Now    func() time.Time
+}
+
+// Helper to get the current time of the throttle
+func (t *LoginThrottle) now() time.Time {
+	if t.Now != nil {
+		return t.Now()
+	}
+	return time.Now()
+}
+
+// Check to get how long to wait before an attempt of the subjects is allowed, 0 if it is allowed now.
+// It does not count the attempt, use Reserve before checking credentials.
+func (t *LoginThrottle) Check(subjects []LoginSubject) (time.Duration, error) {
+	keys := make([]string, len(subjects))
+	for i, s := range subjects {
+		keys[i] = s.Key()
+	}
+	blocks, err := t.Counters.LoginBlocks(keys)
+	if err != nil {
+		return 0, fmt.Errorf("LoginBlocks %v", err)
+	}
+	now := t.now()
+	var wait time.Duration
+	for i, b := range blocks {
+		if d := b.DelayUntil.Sub(now); d > wait {
+			wait = d
+		}
+		if b.LockoutID == 0 {
+			continue
+		}
+		lockout, err := t.Lockouts.GetLockout(b.LockoutID)
+		if err != nil {
+			return 0, fmt.Errorf("GetLockout %v", err)
+		}
+		if !lockout.Active(now) {
+			// Unlocked by an administrator
+			if err := t.Counters.ResetLogin(keys[i]); err != nil {
+				return 0, fmt.Errorf("ResetLogin %v", err)
+			}
+			continue
+		}
+		if d := lockout.LockedUntil.Sub(now); d > wait {
+			wait = d
+		}
+	}
+	return wait, nil
+}
+
+// Reserve to count an attempt of the subjects before their credentials are checked, returns how long to wait
+// if the attempt is not allowed. Attempts are counted as failures until Success, and the delay for the next
+// attempt is set with the count, so concurrent attempts can not skip it. Only the first attempt after the limit
+// creates the lockout, since each count is returned once.
+func (t *LoginThrottle) Reserve(subjects []LoginSubject) (time.Duration, error) {
+	policy := t.Policy()
+	now := t.now()
+	var wait time.Duration
+	for _, s := range subjects {
+		delay := func(attempts int64) time.Duration {
+			return policy.Delay(s.Kind, attempts)
+		}
+		attempts, block, err := t.Counters.ReserveLogin(s.Key(), policy.Lockout, delay)
+		if err != nil {
+			return 0, fmt.Errorf("ReserveLogin %v", err)
+		}
+		if block.LockoutID != 0 {
+			lockout, err := t.Lockouts.GetLockout(block.LockoutID)
+			if err != nil {
+				return 0, fmt.Errorf("GetLockout %v", err)
+			}
+			if lockout.Active(now) {
+				if d := lockout.LockedUntil.Sub(now); d > wait {
+					wait = d
+				}
+				continue
+			}
+			// Unlocked by an administrator, the attempt is counted again with clear counters
+			if err := t.Counters.ResetLogin(s.Key()); err != nil {
+				return 0, fmt.Errorf("ResetLogin %v", err)
+			}
+			if attempts, block, err = t.Counters.ReserveLogin(s.Key(), policy.Lockout, delay); err != nil {
+				return 0, fmt.Errorf("ReserveLogin %v", err)
+			}
+		}
+		if attempts == 0 {
+			d := block.DelayUntil.Sub(now)
+			if d <= 0 {
+				d = loginBaseDelay
+			}
+			if d > wait {
+				wait = d
+			}
+			continue
+		}
+		// Attempts up to the limit are allowed, the first one after it locks out the subject
+		failures := attempts - 1
+		limit := policy.Limit(s.Kind)
+		if failures < limit {
+			continue
+		}
+		if policy.Lockout > wait {
+			wait = policy.Lockout
+		}
+		if failures > limit {
+			continue
+		}
+		lockout := LoginLockout{
+			Kind:        s.Kind,
+			Subject:     s.ID,
+			Failures:    failures,
+			LockedUntil: now.Add(policy.Lockout),
+		}
+		if err := t.Lockouts.CreateLockout(&lockout); err != nil {
+			return wait, fmt.Errorf("CreateLockout %v", err)
+		}
+		if err := t.Counters.SetLoginLockout(s.Key(), lockout.ID, policy.Lockout); err != nil {
+			return wait, fmt.Errorf("SetLoginLockout %v", err)
+		}
+		log.Printf("login lockout of %s %s until %s after %d failures", s.Kind, s.ID, lockout.LockedUntil.Format(time.RFC3339), failures)
+		if t.Notify != nil {
+			t.Notify(lockout)
+		}
+	}
+	return wait, nil
+}
+
+// Success to clear the attempts of the subjects after a successful login
+func (t *LoginThrottle) Success(subjects []LoginSubject) error {
+	for _, s := range subjects {
+		if err := t.Counters.ResetLogin(s.Key()); err != nil {
+			return fmt.Errorf("ResetLogin %v", err)
+		}
+	}
+	return nil
+}
+
+// RetryAfter to get the value of the Retry-After header for a wait, in seconds rounded up
+func RetryAfter(wait time.Duration) string {
+	return strconv.FormatInt(int64((wait+time.Second-1)/time.Second), 10)
+}
+
+// NotifyLockout to send a lockout as JSON to a webhook, errors are only logged
+func NotifyLockout(webhook, service string, lockout LoginLockout) {
+	if webhook == "" {
+		return
+	}
+	payload, err := json.Marshal(map[string]interface{}{
+		"event":        "login_lockout",
+		"service":      service,
+		"kind":         lockout.Kind,
+		"subject":      lockout.Subject,
+		"failures":     lockout.Failures,
+		"locked_until": lockout.LockedUntil,
+	})
+	if err != nil {
+		log.Printf("error serializing lockout %v", err)
+		return
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(webhook, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		log.Printf("error notifying lockout %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("error notifying lockout, webhook returned %d", resp.StatusCode)
+	}
+}
+
+// CreateLockout to record a new lockout
+func (m *UserManager) CreateLockout(lockout *LoginLockout) error {
+	if err := m.DB.Create(lockout).Error; err != nil {
+		return fmt.Errorf("Create LoginLockout %v", err)
+	}
+	return nil
+}
+
+// GetLockout to get a lockout by ID
+func (m *UserManager) GetLockout(id uint) (LoginLockout, error) {
+	var lockout LoginLockout
+	if err := m.DB.First(&lockout, id).Error; err != nil {
+		return lockout, err
+	}
+	return lockout, nil
+}
+
+// ActiveLockouts to get the lockouts still preventing logins
+func (m *UserManager) ActiveLockouts() ([]LoginLockout, error) {
+	var lockouts []LoginLockout
+	err := m.DB.Where("locked_until > ? AND unlocked = ?", time.Now(), false).
+		Order("created_at desc").Find(&lockouts).Error
+	if err != nil {
+		return lockouts, err
+	}
+	return lockouts, nil
+}
+
+// UnlockLogin to end the active lockouts of a subject, returns how many were unlocked.
+// Services clear their counters the next time the subject tries to login.
+func (m *UserManager) UnlockLogin(kind, subject, unlockedBy string) (int64, error) {
+	tx := m.DB.Model(&LoginLockout{}).
+		Where("kind = ? AND lower(subject) = ? AND locked_until > ? AND unlocked = ?", kind, strings.ToLower(subject), time.Now(), false).
+		Updates(map[string]interface{}{"unlocked": true, "unlocked_at": time.Now(), "unlocked_by": unlockedBy})
+	if tx.Error != nil {
+		return 0, fmt.Errorf("Updates %v", tx.Error)
+	}
+	if tx.RowsAffected > 0 {
+		log.Printf("login lockout of %s %s unlocked by %s", kind, subject, unlockedBy)
+	}
+	return tx.RowsAffected, nil
+}
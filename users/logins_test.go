@@ -0,0 +1,288 @@
+package users
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Counters and lockouts in memory, expiring with the clock of the test
+type testLogins struct {
+	mu        sync.Mutex
+	now       time.Time
+	failures  map[string]int64
+	delays    map[string]time.Time
+	locks     map[string]uint
+	lockUntil map[string]time.Time
+	lockouts  []LoginLockout
+}
+
+func newTestLogins() *testLogins {
+	return &testLogins{
+		now:       time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC),
+		failures:  make(map[string]int64),
+		delays:    make(map[string]time.Time),
+		locks:     make(map[string]uint),
+		lockUntil: make(map[string]time.Time),
+	}
+}
+
+func (l *testLogins) LoginBlocks(keys []string) ([]LoginBlock, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var blocks []LoginBlock
+	for _, k := range keys {
+		var b LoginBlock
+		if l.now.Before(l.delays[k]) {
+			b.DelayUntil = l.delays[k]
+		}
+		if l.now.Before(l.lockUntil[k]) {
+			b.LockoutID = l.locks[k]
+		}
+		blocks = append(blocks, b)
+	}
+	return blocks, nil
+}
+
+func (l *testLogins) ReserveLogin(key string, window time.Duration, delay func(int64) time.Duration) (int64, LoginBlock, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var b LoginBlock
+	if l.now.Before(l.lockUntil[key]) {
+		b.LockoutID = l.locks[key]
+	}
+	if l.now.Before(l.delays[key]) {
+		b.DelayUntil = l.delays[key]
+	}
+	if b.LockoutID != 0 || !b.DelayUntil.IsZero() {
+		return 0, b, nil
+	}
+	l.failures[key]++
+	if d := delay(l.failures[key]); d > 0 {
+		l.delays[key] = l.now.Add(d)
+	}
+	return l.failures[key], b, nil
+}
+
+func (l *testLogins) SetLoginLockout(key string, id uint, duration time.Duration) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.locks[key] = id
+	l.lockUntil[key] = l.now.Add(duration)
+	delete(l.failures, key)
+	delete(l.delays, key)
+	return nil
+}
+
+func (l *testLogins) ResetLogin(key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.failures, key)
+	delete(l.delays, key)
+	delete(l.locks, key)
+	delete(l.lockUntil, key)
+	return nil
+}
+
+func (l *testLogins) CreateLockout(lockout *LoginLockout) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lockout.ID = uint(len(l.lockouts) + 1)
+	l.lockouts = append(l.lockouts, *lockout)
+	return nil
+}
+
+func (l *testLogins) GetLockout(id uint) (LoginLockout, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if id == 0 || int(id) > len(l.lockouts) {
+		return LoginLockout{}, fmt.Errorf("lockout %d not found", id)
+	}
+	return l.lockouts[id-1], nil
+}
+
+func newTestThrottle() (*LoginThrottle, *testLogins, *[]LoginLockout) {
+	l := newTestLogins()
+	var notified []LoginLockout
+	t := &LoginThrottle{
+		Counters: l,
+		Lockouts: l,
+		Policy:   func() LoginPolicy { return NewLoginPolicy(5, 15) },
+		Notify: func(lockout LoginLockout) {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			notified = append(notified, lockout)
+		},
+		Now: func() time.Time { return l.now },
+	}
+	return t, l, &notified
+}
+
+func TestLoginPolicyDelay(t *testing.T) {
+	p := NewLoginPolicy(0, 0)
+	assert.Equal(t, DefaultLoginFailures, p.MaxFailures)
+	assert.Equal(t, 15*time.Minute, p.Lockout)
+	assert.Equal(t, time.Duration(0), p.Delay(LoginUser, 1))
+	assert.Equal(t, time.Duration(0), p.Delay(LoginUser, 2))
+	assert.Equal(t, time.Second, p.Delay(LoginUser, 3))
+	assert.Equal(t, 2*time.Second, p.Delay(LoginUser, 4))
+	assert.Equal(t, 4*time.Second, p.Delay(LoginUser, 5))
+	assert.Equal(t, time.Minute, p.Delay(LoginUser, 40))
+	// IP addresses are shared, so they get more failures without delay
+	assert.Equal(t, time.Duration(0), p.Delay(LoginIP, 14))
+	assert.Equal(t, time.Second, p.Delay(LoginIP, 15))
+	assert.Equal(t, loginIPFactor*p.MaxFailures, p.Limit(LoginIP))
+}
+
+func TestLoginThrottleLegitimate(t *testing.T) {
+	throttle, l, notified := newTestThrottle()
+	subjects := LoginSubjects("alice", "10.0.0.1")
+	// Two typos and a successful login are never delayed
+	for i := 0; i < 3; i++ {
+		wait, err := throttle.Reserve(subjects)
+		assert.NoError(t, err)
+		assert.Equal(t, time.Duration(0), wait)
+	}
+	assert.NoError(t, throttle.Success(subjects))
+	assert.Empty(t, l.failures)
+	assert.Empty(t, l.delays)
+	// Counters start again after the successful login
+	wait, err := throttle.Reserve(subjects)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), wait)
+	assert.Equal(t, int64(1), l.failures[subjects[0].Key()])
+	assert.Empty(t, *notified)
+}
+
+func TestLoginThrottleBackoff(t *testing.T) {
+	throttle, l, _ := newTestThrottle()
+	subjects := LoginSubjects("alice", "10.0.0.1")
+	for i := 0; i < 3; i++ {
+		wait, err := throttle.Reserve(subjects)
+		assert.NoError(t, err)
+		assert.Equal(t, time.Duration(0), wait)
+	}
+	wait, err := throttle.Check(subjects)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Second, wait)
+	// Attempts during the delay are rejected without being counted
+	wait, _ = throttle.Reserve(subjects)
+	assert.Equal(t, time.Second, wait)
+	assert.Equal(t, int64(3), l.failures[subjects[0].Key()])
+	l.now = l.now.Add(time.Second)
+	wait, _ = throttle.Reserve(subjects)
+	assert.Equal(t, time.Duration(0), wait)
+	wait, _ = throttle.Check(subjects)
+	assert.Equal(t, 2*time.Second, wait)
+}
+
+// Helper to fail attempts of the subjects, waiting for each delay
+func failLogins(throttle *LoginThrottle, l *testLogins, subjects []LoginSubject, n int) {
+	for i := 0; i < n; i++ {
+		_, _ = throttle.Reserve(subjects)
+		l.now = l.now.Add(time.Minute)
+	}
+}
+
+func TestLoginThrottleLockout(t *testing.T) {
+	throttle, l, notified := newTestThrottle()
+	subjects := LoginSubjects("Alice", "10.0.0.1")
+	failLogins(throttle, l, subjects, 5)
+	assert.Empty(t, *notified)
+	// The attempt after the limit is rejected and locks out the username
+	wait, err := throttle.Reserve(subjects)
+	assert.NoError(t, err)
+	assert.Equal(t, 15*time.Minute, wait)
+	assert.Len(t, *notified, 1)
+	assert.Equal(t, LoginUser, (*notified)[0].Kind)
+	assert.Equal(t, int64(5), (*notified)[0].Failures)
+	wait, err = throttle.Check(subjects)
+	assert.NoError(t, err)
+	assert.Equal(t, 15*time.Minute, wait)
+	// Usernames are locked regardless of case and source
+	wait, _ = throttle.Reserve(LoginSubjects("alice", "10.0.0.2"))
+	assert.Equal(t, 15*time.Minute, wait)
+	assert.Len(t, *notified, 1)
+	// The lockout expires
+	l.now = l.now.Add(15 * time.Minute)
+	wait, _ = throttle.Check(subjects)
+	assert.Equal(t, time.Duration(0), wait)
+}
+
+func TestLoginThrottleUnlock(t *testing.T) {
+	throttle, l, _ := newTestThrottle()
+	subjects := LoginSubjects("alice", "10.0.0.1")
+	failLogins(throttle, l, subjects, 6)
+	wait, _ := throttle.Check(subjects)
+	assert.True(t, wait > 0)
+	// An administrator unlocks the username
+	l.lockouts[0].Unlocked = true
+	wait, err := throttle.Reserve(subjects)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), wait)
+	assert.Zero(t, l.locks[subjects[0].Key()])
+	assert.Equal(t, int64(1), l.failures[subjects[0].Key()])
+}
+
+func TestLoginThrottleConcurrent(t *testing.T) {
+	throttle, _, notified := newTestThrottle()
+	subjects := LoginSubjects("alice", "10.0.0.1")
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wait, err := throttle.Reserve(subjects)
+			assert.NoError(t, err)
+			if wait == 0 {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	// Only the free attempts and the first delayed one are checked
+	assert.Equal(t, int(loginFreeFailures), allowed)
+	assert.Empty(t, *notified)
+}
+
+func TestLoginThrottleConcurrentLockout(t *testing.T) {
+	throttle, _, notified := newTestThrottle()
+	// Lockouts before any delay, so all the attempts are counted
+	throttle.Policy = func() LoginPolicy { return NewLoginPolicy(2, 15) }
+	subjects := LoginSubjects("alice", "10.0.0.1")
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := throttle.Reserve(subjects)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	// One lockout for each subject
+	assert.Len(t, *notified, 2)
+	assert.NotEqual(t, (*notified)[0].Kind, (*notified)[1].Kind)
+}
+
+func TestRetryAfter(t *testing.T) {
+	assert.Equal(t, "1", RetryAfter(time.Second))
+	assert.Equal(t, "2", RetryAfter(1500*time.Millisecond))
+	assert.Equal(t, "900", RetryAfter(15*time.Minute))
+}
+
+func TestTokenSubjects(t *testing.T) {
+	subjects := TokenSubjects("secret-token", "10.0.0.1")
+	assert.Equal(t, LoginToken, subjects[0].Kind)
+	assert.Len(t, subjects[0].ID, 16)
+	assert.False(t, strings.Contains(subjects[0].Key(), "secret"))
+	assert.Equal(t, subjects, TokenSubjects("secret-token", "10.0.0.1"))
+}
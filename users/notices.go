@@ -0,0 +1,348 @@
+package users
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Severities of notices
+const (
+	NoticeInfo     = "info"
+	NoticeWarning  = "warning"
+	NoticeCritical = "critical"
+)
+
+// NoticeSeverities to list all the severities of notices, from lowest to highest
+var NoticeSeverities = []string{NoticeInfo, NoticeWarning, NoticeCritical}
+
+// Notice to hold a banner shown to admin users, for all environments or only some of them.
+// Deleted notices are kept, with expired ones, as history for audit.
+type Notice struct {
+	gorm.Model
+	Message  string
+	Severity string
+	// Comma separated UUIDs of environments, empty for a global notice
+	Environments string
+	// Zero for notices that do not expire
+	ExpiresAt time.Time
+	// Users must acknowledge the notice, it is shown until they do
+	RequireAck bool
+	// Critical notices of environments can require a confirmation to run queries and carves in them
+	ConfirmActions bool
+	Creator        string
+	RemovedBy      string
+}
+
+// NoticeAck to record that a user acknowledged a notice
+type NoticeAck struct {
+	gorm.Model
+	NoticeID uint   `gorm:"uniqueIndex:idx_notice_ack"`
+	Username string `gorm:"uniqueIndex:idx_notice_ack"`
+}
+
+// Global to check if a notice is shown for all environments
+func (n Notice) Global() bool {
+	return n.Environments == ""
+}
+
+// EnvironmentList to get the UUIDs of the environments of a notice
+func (n Notice) EnvironmentList() []string {
+	if n.Global() {
+		return []string{}
+	}
+	return strings.Split(n.Environments, ",")
+}
+
+// InScope to check if a notice is shown in an environment, empty for pages without environment
+func (n Notice) InScope(envUUID string) bool {
+	if n.Global() {
+		return true
+	}
+	for _, e := range n.EnvironmentList() {
+		if e == envUUID {
+			return true
+		}
+	}
+	return false
+}
+
+// Expired to check if a notice is past its expiration
+func (n Notice) Expired(now time.Time) bool {
+	return !n.ExpiresAt.IsZero() && !now.Before(n.ExpiresAt)
+}
+
+// Active to check if a notice is shown, deleted notices are not active
+func (n Notice) Active(now time.Time) bool {
+	return !n.DeletedAt.Valid && !n.Expired(now)
+}
+
+// Confirms to check if a notice requires a confirmation to run queries and carves in an environment
+func (n Notice) Confirms(envUUID string, now time.Time) bool {
+	return n.ConfirmActions && n.Severity == NoticeCritical && !n.Global() && n.InScope(envUUID) && n.Active(now)
+}
+
+// Helper to check if a severity is valid
+func validSeverity(severity string) bool {
+	for _, s := range NoticeSeverities {
+		if s == severity {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateNotice to check a notice before saving it, the environments are sorted and without duplicates
+func ValidateNotice(n *Notice) error {
+	n.Message = strings.TrimSpace(n.Message)
+	if n.Message == "" {
+		return fmt.Errorf("message is required")
+	}
+	if n.Severity == "" {
+		n.Severity = NoticeInfo
+	}
+	if !validSeverity(n.Severity) {
+		return fmt.Errorf("invalid severity %s, must be one of %s", n.Severity, strings.Join(NoticeSeverities, ", "))
+	}
+	seen := make(map[string]bool)
+	envs := []string{}
+	for _, e := range strings.Split(n.Environments, ",") {
+		if e = strings.TrimSpace(e); e != "" && !seen[e] {
+			seen[e] = true
+			envs = append(envs, e)
+		}
+	}
+	sort.Strings(envs)
+	n.Environments = strings.Join(envs, ",")
+	if n.ConfirmActions && (n.Severity != NoticeCritical || n.Global()) {
+		return fmt.Errorf("only critical notices of environments can require a confirmation")
+	}
+	return nil
+}
+
+// ParseNoticeExpiry to parse the expiration of a notice, as a date, a date and time or a duration from now like 48h or 3d.
+// Empty is a notice that does not expire.
+func ParseNoticeExpiry(expiry string, now time.Time) (time.Time, error) {
+	expiry = strings.TrimSpace(expiry)
+	if expiry == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.Parse(layout, expiry); err == nil {
+			return t, nil
+		}
+	}
+	if strings.HasSuffix(expiry, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(expiry, "d"))
+		if err == nil && days > 0 {
+			return now.AddDate(0, 0, days), nil
+		}
+	} else if d, err := time.ParseDuration(expiry); err == nil && d > 0 {
+		return now.Add(d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid expiration %s", expiry)
+}
+
+// VisibleNotices to get the notices shown in a page of an environment, empty for pages without environment.
+// Notices that require acknowledgment are not shown once acknowledged. Critical notices go first.
+func VisibleNotices(notices []Notice, envUUID string, acked map[uint]bool, now time.Time) []Notice {
+	visible := []Notice{}
+	for _, n := range notices {
+		if !n.Active(now) || !n.InScope(envUUID) {
+			continue
+		}
+		if n.RequireAck && acked[n.ID] {
+			continue
+		}
+		visible = append(visible, n)
+	}
+	rank := func(severity string) int {
+		for i, s := range NoticeSeverities {
+			if s == severity {
+				return i
+			}
+		}
+		return 0
+	}
+	sort.SliceStable(visible, func(i, j int) bool {
+		return rank(visible[i].Severity) > rank(visible[j].Severity)
+	})
+	return visible
+}
+
+// ConfirmNotices to get the notices that require a confirmation to run queries and carves in an environment
+func ConfirmNotices(notices []Notice, envUUID string, now time.Time) []Notice {
+	confirm := []Notice{}
+	for _, n := range notices {
+		if n.Confirms(envUUID, now) {
+			confirm = append(confirm, n)
+		}
+	}
+	return confirm
+}
+
+// ConfirmationMessage to explain why running queries and carves needs a confirmation, empty if it does not
+func ConfirmationMessage(notices []Notice) string {
+	if len(notices) == 0 {
+		return ""
+	}
+	messages := []string{}
+	for _, n := range notices {
+		messages = append(messages, n.Message)
+	}
+	return "critical notices must be confirmed to run queries and carves: " + strings.Join(messages, "; ")
+}
+
+// CheckConfirmation to check that the critical notices of an environment were confirmed to run queries and carves
+func (m *UserManager) CheckConfirmation(envUUID string, confirmed bool) error {
+	if confirmed {
+		return nil
+	}
+	now := time.Now()
+	notices, err := m.ActiveNotices(now)
+	if err != nil {
+		return fmt.Errorf("error getting notices %v", err)
+	}
+	if msg := ConfirmationMessage(ConfirmNotices(notices, envUUID, now)); msg != "" {
+		return fmt.Errorf("%s", msg)
+	}
+	return nil
+}
+
+// NewNotice to create a notice
+func (m *UserManager) NewNotice(n Notice) (Notice, error) {
+	if err := ValidateNotice(&n); err != nil {
+		return n, err
+	}
+	n.ID = 0
+	if err := m.DB.Create(&n).Error; err != nil {
+		return n, fmt.Errorf("Create Notice %v", err)
+	}
+	return n, nil
+}
+
+// UpdateNotice to change a notice, acknowledgments are kept
+func (m *UserManager) UpdateNotice(id uint, n Notice) (Notice, error) {
+	existing, err := m.GetNotice(id)
+	if err != nil {
+		return n, err
+	}
+	if err := ValidateNotice(&n); err != nil {
+		return n, err
+	}
+	if err := m.DB.Model(&existing).Updates(map[string]interface{}{
+		"message":         n.Message,
+		"severity":        n.Severity,
+		"environments":    n.Environments,
+		"expires_at":      n.ExpiresAt,
+		"require_ack":     n.RequireAck,
+		"confirm_actions": n.ConfirmActions,
+	}).Error; err != nil {
+		return n, fmt.Errorf("Updates %v", err)
+	}
+	return m.GetNotice(id)
+}
+
+// GetNotice to get a notice by ID, deleted notices are not returned
+func (m *UserManager) GetNotice(id uint) (Notice, error) {
+	var n Notice
+	if err := m.DB.Where("id = ?", id).First(&n).Error; err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+// DeleteNotice to remove a notice, it is kept for the history with the user that removed it
+func (m *UserManager) DeleteNotice(id uint, username string) error {
+	n, err := m.GetNotice(id)
+	if err != nil {
+		return err
+	}
+	if err := m.DB.Model(&n).Update("removed_by", username).Error; err != nil {
+		return fmt.Errorf("Update %v", err)
+	}
+	if err := m.DB.Delete(&n).Error; err != nil {
+		return fmt.Errorf("Delete %v", err)
+	}
+	return nil
+}
+
+// ActiveNotices to get the notices that are not deleted nor expired
+func (m *UserManager) ActiveNotices(now time.Time) ([]Notice, error) {
+	var all []Notice
+	notices := []Notice{}
+	if err := m.DB.Order("created_at DESC").Find(&all).Error; err != nil {
+		return notices, err
+	}
+	for _, n := range all {
+		if n.Active(now) {
+			notices = append(notices, n)
+		}
+	}
+	return notices, nil
+}
+
+// NoticesHistory to get all notices, with the deleted and expired ones
+func (m *UserManager) NoticesHistory() ([]Notice, error) {
+	var notices []Notice
+	if err := m.DB.Unscoped().Order("created_at DESC").Find(&notices).Error; err != nil {
+		return notices, err
+	}
+	return notices, nil
+}
+
+// AckNotice to record that a user acknowledged a notice, acknowledging it again changes nothing
+func (m *UserManager) AckNotice(id uint, username string) error {
+	if _, err := m.GetNotice(id); err != nil {
+		return err
+	}
+	ack := NoticeAck{NoticeID: id, Username: username}
+	if err := m.DB.Where(NoticeAck{NoticeID: id, Username: username}).FirstOrCreate(&ack).Error; err != nil {
+		return fmt.Errorf("FirstOrCreate NoticeAck %v", err)
+	}
+	return nil
+}
+
+// AckedNotices to get the IDs of the notices acknowledged by a user
+func (m *UserManager) AckedNotices(username string) (map[uint]bool, error) {
+	var acks []NoticeAck
+	acked := make(map[uint]bool)
+	if err := m.DB.Where("username = ?", username).Find(&acks).Error; err != nil {
+		return acked, err
+	}
+	for _, a := range acks {
+		acked[a.NoticeID] = true
+	}
+	return acked, nil
+}
+
+// NoticeAcks to get the acknowledgments of all notices by notice ID, oldest first
+func (m *UserManager) NoticeAcks() (map[uint][]NoticeAck, error) {
+	var acks []NoticeAck
+	byNotice := make(map[uint][]NoticeAck)
+	if err := m.DB.Order("created_at").Find(&acks).Error; err != nil {
+		return byNotice, err
+	}
+	for _, a := range acks {
+		byNotice[a.NoticeID] = append(byNotice[a.NoticeID], a)
+	}
+	return byNotice, nil
+}
+
+// UserNotices to get the notices shown to a user in a page of an environment, empty for pages without environment
+func (m *UserManager) UserNotices(username, envUUID string) ([]Notice, error) {
+	now := time.Now()
+	notices, err := m.ActiveNotices(now)
+	if err != nil {
+		return nil, err
+	}
+	acked, err := m.AckedNotices(username)
+	if err != nil {
+		return nil, err
+	}
+	return VisibleNotices(notices, envUUID, acked, now), nil
+}
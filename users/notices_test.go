@@ -0,0 +1,80 @@
+package users
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestValidateNotice(t *testing.T) {
+	n := Notice{Message: "  prod config freeze until Friday ", Environments: "env-b, env-a,,env-b"}
+	assert.NoError(t, ValidateNotice(&n))
+	assert.Equal(t, "prod config freeze until Friday", n.Message)
+	assert.Equal(t, NoticeInfo, n.Severity)
+	assert.Equal(t, "env-a,env-b", n.Environments)
+	assert.Equal(t, []string{"env-a", "env-b"}, n.EnvironmentList())
+	assert.Error(t, ValidateNotice(&Notice{Message: " "}))
+	assert.Error(t, ValidateNotice(&Notice{Message: "freeze", Severity: "urgent"}))
+	// Only critical notices of environments confirm actions
+	assert.Error(t, ValidateNotice(&Notice{Message: "incident", Severity: NoticeCritical, ConfirmActions: true}))
+	assert.Error(t, ValidateNotice(&Notice{Message: "incident", Severity: NoticeWarning, Environments: "env-a", ConfirmActions: true}))
+	assert.NoError(t, ValidateNotice(&Notice{Message: "incident", Severity: NoticeCritical, Environments: "env-a", ConfirmActions: true}))
+}
+
+func TestVisibleNotices(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	notices := []Notice{
+		{Model: gorm.Model{ID: 1}, Message: "freeze", Severity: NoticeInfo},
+		{Model: gorm.Model{ID: 2}, Message: "incident", Severity: NoticeCritical, Environments: "env-a", ConfirmActions: true},
+		{Model: gorm.Model{ID: 3}, Message: "maintenance", Severity: NoticeWarning, RequireAck: true},
+		{Model: gorm.Model{ID: 4}, Message: "expired", Severity: NoticeWarning, ExpiresAt: now},
+		{Model: gorm.Model{ID: 5, DeletedAt: gorm.DeletedAt{Time: now, Valid: true}}, Message: "deleted", Severity: NoticeWarning},
+		{Model: gorm.Model{ID: 6}, Message: "later", Severity: NoticeInfo, Environments: "env-b", ExpiresAt: now.Add(time.Hour)},
+	}
+	ids := func(notices []Notice) []uint {
+		r := []uint{}
+		for _, n := range notices {
+			r = append(r, n.ID)
+		}
+		return r
+	}
+	assert.Equal(t, []uint{3, 1}, ids(VisibleNotices(notices, "", nil, now)))
+	assert.Equal(t, []uint{2, 3, 1}, ids(VisibleNotices(notices, "env-a", nil, now)))
+	assert.Equal(t, []uint{3, 1, 6}, ids(VisibleNotices(notices, "env-b", nil, now)))
+	// Acknowledged notices are hidden only when they require it
+	acked := map[uint]bool{1: true, 3: true}
+	assert.Equal(t, []uint{2, 1}, ids(VisibleNotices(notices, "env-a", acked, now)))
+	assert.Equal(t, []uint{1}, ids(VisibleNotices(notices, "env-b", acked, now.Add(time.Hour))))
+	// Confirmation only in the environments of the critical notice while it is active
+	assert.Equal(t, []uint{2}, ids(ConfirmNotices(notices, "env-a", now)))
+	assert.Equal(t, "critical notices must be confirmed to run queries and carves: incident", ConfirmationMessage(ConfirmNotices(notices, "env-a", now)))
+	assert.Equal(t, "", ConfirmationMessage(nil))
+	assert.Equal(t, []uint{}, ids(ConfirmNotices(notices, "env-b", now)))
+	notices[1].ExpiresAt = now
+	assert.Equal(t, []uint{}, ids(ConfirmNotices(notices, "env-a", now)))
+}
+
+func TestParseNoticeExpiry(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	expiry, err := ParseNoticeExpiry("", now)
+	assert.NoError(t, err)
+	assert.True(t, expiry.IsZero())
+	expiry, err = ParseNoticeExpiry("2024-05-17", now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 5, 17, 0, 0, 0, 0, time.UTC), expiry)
+	expiry, err = ParseNoticeExpiry("2024-05-17T18:30", now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 5, 17, 18, 30, 0, 0, time.UTC), expiry)
+	expiry, err = ParseNoticeExpiry("48h", now)
+	assert.NoError(t, err)
+	assert.Equal(t, now.Add(48*time.Hour), expiry)
+	expiry, err = ParseNoticeExpiry("3d", now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 5, 13, 12, 0, 0, 0, time.UTC), expiry)
+	for _, invalid := range []string{"friday", "-2h", "0d"} {
+		_, err := ParseNoticeExpiry(invalid, now)
+		assert.Error(t, err, invalid)
+	}
+}
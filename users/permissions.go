@@ -3,6 +3,7 @@ package users
 import (
 	"fmt"
 	"log"
+	"sort"
 
 	"github.com/jmpsec/osctrl/environments"
 	"gorm.io/gorm"
@@ -19,6 +20,16 @@ type EnvAccess struct {
 	Admin bool `json:"admin"`
 }
 
+// CanQuery to check if the access allows to run queries, admins can always run them
+func (a EnvAccess) CanQuery() bool {
+	return a.Admin || a.Query
+}
+
+// CanCarve to check if the access allows to carve files, admins can always carve them
+func (a EnvAccess) CanCarve() bool {
+	return a.Admin || a.Carve
+}
+
 // UserPermission to hold all permissions for users
 type UserPermission struct {
 	gorm.Model
@@ -133,16 +144,17 @@ func (m *UserManager) CheckPermissions(username string, level AccessLevel, envir
 	if err := m.DB.Where("username = ? AND environment = ?", username, environment).Find(&perms).Error; err != nil {
 		return false
 	}
+	access := false
 	for _, p := range perms {
-		// Access is yes for admins
+		// Access is yes for admins, whatever the order of the permissions
 		if p.AccessType == int(AdminLevel) && p.AccessValue {
 			return true
 		}
 		if p.AccessType == int(level) {
-			return p.AccessValue
+			access = p.AccessValue
 		}
 	}
-	return false
+	return access
 }
 
 // ChangePermissions for setting user permissions by username
@@ -317,3 +329,46 @@ func (m *UserManager) DeletePermissions(username, environment string) error {
 	}
 	return nil
 }
+
+// MigrateCarvePermissions to add the carve permission to users that only have the query permission for an
+// environment, with the same value, so carves keep the access they had when both permissions were the same
+func (m *UserManager) MigrateCarvePermissions() (int, error) {
+	var perms []UserPermission
+	if err := m.DB.Where("access_type IN ?", []int{int(QueryLevel), int(CarveLevel)}).Find(&perms).Error; err != nil {
+		return 0, fmt.Errorf("Find UserPermission %v", err)
+	}
+	carve := make(map[string]bool)
+	for _, p := range perms {
+		if p.AccessType == int(CarveLevel) {
+			carve[p.Username+"/"+p.Environment] = true
+		}
+	}
+	var missing []UserPermission
+	for _, p := range perms {
+		if p.AccessType != int(QueryLevel) || carve[p.Username+"/"+p.Environment] {
+			continue
+		}
+		carve[p.Username+"/"+p.Environment] = true
+		missing = append(missing, UserPermission{
+			Username:      p.Username,
+			AccessType:    int(CarveLevel),
+			AccessValue:   p.AccessValue,
+			Environment:   p.Environment,
+			EnvironmentID: p.EnvironmentID,
+			GrantedBy:     p.GrantedBy,
+		})
+	}
+	if len(missing) == 0 {
+		return 0, nil
+	}
+	sort.Slice(missing, func(i, j int) bool {
+		if missing[i].Username != missing[j].Username {
+			return missing[i].Username < missing[j].Username
+		}
+		return missing[i].Environment < missing[j].Environment
+	})
+	if err := m.DB.Create(&missing).Error; err != nil {
+		return 0, fmt.Errorf("Create UserPermission %v", err)
+	}
+	return len(missing), nil
+}
@@ -48,6 +48,45 @@ func TestPermissions(t *testing.T) {
 		mock.ExpectExec(`CREATE INDEX IF NOT EXISTS .*`).WillReturnResult(sqlmock.NewResult(1, 1))
 		mock.ExpectExec(`CREATE INDEX IF NOT EXISTS .*`).WillReturnResult(sqlmock.NewResult(1, 1))
 
+		mock.ExpectQuery(
+			regexp.QuoteMeta(`SELECT count(*) FROM information_schema.tables WHERE table_schema = CURRENT_SCHEMA() AND table_name = $1 AND table_type = $2`)).WithArgs("permission_changes", "BASE TABLE").WillReturnRows(sqlmock.NewRows([]string{"count(*)"}), sqlmock.NewRows([]string{"RowsAffected"}).AddRow(1).AddRow(1))
+		mock.ExpectExec(`CREATE TABLE "permission_changes" .*`).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`CREATE INDEX IF NOT EXISTS .*`).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`CREATE INDEX IF NOT EXISTS .*`).WillReturnResult(sqlmock.NewResult(1, 1))
+
+		mock.ExpectQuery(
+			regexp.QuoteMeta(`SELECT count(*) FROM information_schema.tables WHERE table_schema = CURRENT_SCHEMA() AND table_name = $1 AND table_type = $2`)).WithArgs("api_keys", "BASE TABLE").WillReturnRows(sqlmock.NewRows([]string{"count(*)"}), sqlmock.NewRows([]string{"RowsAffected"}).AddRow(1).AddRow(1))
+		mock.ExpectExec(`CREATE TABLE "api_keys" .*`).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`CREATE (UNIQUE )?INDEX IF NOT EXISTS .*`).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`CREATE (UNIQUE )?INDEX IF NOT EXISTS .*`).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`CREATE (UNIQUE )?INDEX IF NOT EXISTS .*`).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`CREATE (UNIQUE )?INDEX IF NOT EXISTS .*`).WillReturnResult(sqlmock.NewResult(1, 1))
+
+		mock.ExpectQuery(
+			regexp.QuoteMeta(`SELECT count(*) FROM information_schema.tables WHERE table_schema = CURRENT_SCHEMA() AND table_name = $1 AND table_type = $2`)).WithArgs("login_lockouts", "BASE TABLE").WillReturnRows(sqlmock.NewRows([]string{"count(*)"}), sqlmock.NewRows([]string{"RowsAffected"}).AddRow(1).AddRow(1))
+		mock.ExpectExec(`CREATE TABLE "login_lockouts" .*`).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`CREATE INDEX IF NOT EXISTS .*`).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`CREATE INDEX IF NOT EXISTS .*`).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`CREATE INDEX IF NOT EXISTS .*`).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectQuery(
+			regexp.QuoteMeta(`SELECT count(*) FROM information_schema.tables WHERE table_schema = CURRENT_SCHEMA() AND table_name = $1 AND table_type = $2`)).WithArgs("notices", "BASE TABLE").WillReturnRows(sqlmock.NewRows([]string{"count(*)"}), sqlmock.NewRows([]string{"RowsAffected"}).AddRow(1).AddRow(1))
+		mock.ExpectExec(`CREATE TABLE "notices" .*`).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`CREATE INDEX IF NOT EXISTS .*`).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectQuery(
+			regexp.QuoteMeta(`SELECT count(*) FROM information_schema.tables WHERE table_schema = CURRENT_SCHEMA() AND table_name = $1 AND table_type = $2`)).WithArgs("notice_acks", "BASE TABLE").WillReturnRows(sqlmock.NewRows([]string{"count(*)"}), sqlmock.NewRows([]string{"RowsAffected"}).AddRow(1).AddRow(1))
+		mock.ExpectExec(`CREATE TABLE "notice_acks" .*`).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`CREATE (UNIQUE )?INDEX IF NOT EXISTS .*`).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`CREATE (UNIQUE )?INDEX IF NOT EXISTS .*`).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectQuery(
+			regexp.QuoteMeta(`SELECT count(*) FROM information_schema.tables WHERE table_schema = CURRENT_SCHEMA() AND table_name = $1 AND table_type = $2`)).WithArgs("setup_states", "BASE TABLE").WillReturnRows(sqlmock.NewRows([]string{"count(*)"}), sqlmock.NewRows([]string{"RowsAffected"}).AddRow(1).AddRow(1))
+		mock.ExpectExec(`CREATE TABLE "setup_states" .*`).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectQuery(
+			regexp.QuoteMeta(`SELECT count(*) FROM information_schema.tables WHERE table_schema = CURRENT_SCHEMA() AND table_name = $1 AND table_type = $2`)).WithArgs("team_members", "BASE TABLE").WillReturnRows(sqlmock.NewRows([]string{"count(*)"}), sqlmock.NewRows([]string{"RowsAffected"}).AddRow(1).AddRow(1))
+		mock.ExpectExec(`CREATE TABLE "team_members" .*`).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`CREATE (UNIQUE )?INDEX IF NOT EXISTS .*`).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`CREATE (UNIQUE )?INDEX IF NOT EXISTS .*`).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`CREATE (UNIQUE )?INDEX IF NOT EXISTS .*`).WillReturnResult(sqlmock.NewResult(1, 1))
+
 		manager = CreateUserManager(_postgres, &conf)
 
 		assert.NotEqual(t, nil, manager)
@@ -454,3 +493,66 @@ func TestPermissions(t *testing.T) {
 		assert.NoError(t, err)
 	})
 }
+
+func TestCarvePermissions(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db, got error: %v", err)
+	}
+	defer mockDB.Close()
+	_postgres, err := gorm.Open(postgres.New(postgres.Config{Conn: mockDB}), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("unable to create new postgres database: %v", err)
+	}
+	manager := &UserManager{DB: _postgres}
+	rows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"username", "access_type", "access_value", "environment"}).
+			AddRow("alice", UserLevel, true, "dev").
+			AddRow("alice", QueryLevel, true, "dev").
+			AddRow("alice", CarveLevel, false, "dev")
+	}
+	// Query access does not grant carve access
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "admin_users"`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(`SELECT \* FROM "user_permissions"`).WillReturnRows(rows())
+	assert.True(t, manager.CheckPermissions("alice", QueryLevel, "dev"))
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "admin_users"`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(`SELECT \* FROM "user_permissions"`).WillReturnRows(rows())
+	assert.False(t, manager.CheckPermissions("alice", CarveLevel, "dev"))
+	// Admins can carve without carve access
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "admin_users"`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(`SELECT \* FROM "user_permissions"`).WillReturnRows(rows().AddRow("alice", AdminLevel, true, "dev"))
+	assert.True(t, manager.CheckPermissions("alice", CarveLevel, "dev"))
+	assert.False(t, EnvAccess{User: true, Query: true}.CanCarve())
+	assert.True(t, EnvAccess{User: true, Query: true}.CanQuery())
+	assert.True(t, EnvAccess{Admin: true}.CanCarve())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMigrateCarvePermissions(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock sql db, got error: %v", err)
+	}
+	defer mockDB.Close()
+	_postgres, err := gorm.Open(postgres.New(postgres.Config{Conn: mockDB}), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("unable to create new postgres database: %v", err)
+	}
+	manager := &UserManager{DB: _postgres}
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "user_permissions" WHERE access_type IN ($1,$2)`)).WithArgs(QueryLevel, CarveLevel).WillReturnRows(
+		sqlmock.NewRows([]string{"username", "access_type", "access_value", "environment", "granted_by"}).
+			AddRow("bob", QueryLevel, false, "dev", "admin").
+			AddRow("alice", QueryLevel, true, "dev", "admin").
+			AddRow("alice", QueryLevel, true, "prod", "admin").
+			AddRow("alice", CarveLevel, false, "prod", "admin"))
+	// Carve access is added with the value of the query access, only where it is missing
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "user_permissions"`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, "alice", CarveLevel, true, "dev", 0, "admin", sqlmock.AnyArg(), sqlmock.AnyArg(), nil, "bob", CarveLevel, false, "dev", 0, "admin").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+	mock.ExpectCommit()
+	added, err := manager.MigrateCarvePermissions()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, added)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
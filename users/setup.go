@@ -0,0 +1,149 @@
+package users
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// SetupStarted for a first-run setup with steps pending
+	SetupStarted string = "started"
+	// SetupCompleted for a first-run setup that is done, it is never available again
+	SetupCompleted string = "completed"
+	// SetupStepUser for the step creating the first admin user
+	SetupStepUser string = "user"
+	// SetupStepEnvironment for the step creating the first environment
+	SetupStepEnvironment string = "environment"
+	// SetupStepSettings for the step saving the basic settings
+	SetupStepSettings string = "settings"
+	// MinPasswordLength is the minimum length of passwords
+	MinPasswordLength int = 12
+)
+
+// SetupState to keep the progress of the first-run setup, so an interrupted setup can be resumed
+type SetupState struct {
+	ID          uint `gorm:"primaryKey"`
+	Status      string
+	Username    string
+	Environment string
+	Steps       string
+	StartedAt   time.Time
+	CompletedAt time.Time
+}
+
+// CheckPasswordPolicy to verify that a password is long enough, mixes letters with digits or symbols and
+// does not contain the username
+func CheckPasswordPolicy(username, password string) error {
+	if len([]rune(password)) < MinPasswordLength {
+		return fmt.Errorf("password must have at least %d characters", MinPasswordLength)
+	}
+	if username != "" && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		return fmt.Errorf("password can not contain the username")
+	}
+	var letters, others bool
+	for _, r := range password {
+		if unicode.IsLetter(r) {
+			letters = true
+		} else if !unicode.IsSpace(r) {
+			others = true
+		}
+	}
+	if !letters || !others {
+		return fmt.Errorf("password must have letters and digits or symbols")
+	}
+	return nil
+}
+
+// CheckSetupToken to compare the token of a setup request in constant time, an empty token never matches
+func CheckSetupToken(expected, token string) bool {
+	if expected == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(token)) == 1
+}
+
+// Done to check if a step of the setup is completed
+func (s SetupState) Done(step string) bool {
+	for _, d := range strings.Split(s.Steps, ",") {
+		if d == step {
+			return true
+		}
+	}
+	return false
+}
+
+// Complete to mark a step of the setup as completed
+func (s *SetupState) Complete(step string) {
+	if s.Done(step) {
+		return
+	}
+	if s.Steps == "" {
+		s.Steps = step
+		return
+	}
+	s.Steps += "," + step
+}
+
+// Resume to check that a setup request continues the setup in progress, which was started for one
+// user and one environment
+func (s SetupState) Resume(username, environment string) error {
+	if s.Status == SetupCompleted {
+		return fmt.Errorf("setup is already completed")
+	}
+	if s.Status != SetupStarted {
+		return nil
+	}
+	if s.Username != "" && s.Username != username {
+		return fmt.Errorf("setup was started for user %s", s.Username)
+	}
+	if s.Environment != "" && s.Environment != environment {
+		return fmt.Errorf("setup was started for environment %s", s.Environment)
+	}
+	return nil
+}
+
+// GetSetup to get the state of the first-run setup, empty if it was never started
+func (m *UserManager) GetSetup() (SetupState, error) {
+	var state SetupState
+	if err := m.DB.First(&state).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return SetupState{}, nil
+		}
+		return state, fmt.Errorf("First SetupState %v", err)
+	}
+	return state, nil
+}
+
+// SaveSetup to keep the state of the first-run setup
+func (m *UserManager) SaveSetup(state *SetupState) error {
+	if err := m.DB.Save(state).Error; err != nil {
+		return fmt.Errorf("Save SetupState %v", err)
+	}
+	return nil
+}
+
+// SetupPending to check if the first-run setup is available: there are no users yet, or the setup
+// was started and interrupted. Once completed, it is never available again.
+func (m *UserManager) SetupPending() (bool, error) {
+	state, err := m.GetSetup()
+	if err != nil {
+		return false, err
+	}
+	switch state.Status {
+	case SetupCompleted:
+		return false, nil
+	case SetupStarted:
+		return true, nil
+	}
+	var count int64
+	if err := m.DB.Model(&AdminUser{}).Count(&count).Error; err != nil {
+		return false, fmt.Errorf("Count AdminUser %v", err)
+	}
+	return count == 0, nil
+}
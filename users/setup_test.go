@@ -0,0 +1,44 @@
+package users
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckPasswordPolicy(t *testing.T) {
+	assert.NoError(t, CheckPasswordPolicy("admin", "correct-horse-battery"))
+	assert.NoError(t, CheckPasswordPolicy("admin", "CorrectHorse42"))
+	assert.Error(t, CheckPasswordPolicy("admin", "short1!"))
+	assert.Error(t, CheckPasswordPolicy("admin", "onlylettersinhere"))
+	assert.Error(t, CheckPasswordPolicy("admin", "123456789012345"))
+	assert.Error(t, CheckPasswordPolicy("admin", "my-ADMIN-password-1"))
+}
+
+func TestCheckSetupToken(t *testing.T) {
+	assert.True(t, CheckSetupToken("token", "token"))
+	assert.False(t, CheckSetupToken("token", "other"))
+	assert.False(t, CheckSetupToken("token", ""))
+	assert.False(t, CheckSetupToken("", ""))
+}
+
+func TestSetupStateSteps(t *testing.T) {
+	var state SetupState
+	assert.False(t, state.Done(SetupStepUser))
+	state.Complete(SetupStepUser)
+	state.Complete(SetupStepEnvironment)
+	state.Complete(SetupStepUser)
+	assert.Equal(t, "user,environment", state.Steps)
+	assert.True(t, state.Done(SetupStepUser))
+	assert.True(t, state.Done(SetupStepEnvironment))
+	assert.False(t, state.Done(SetupStepSettings))
+}
+
+func TestSetupStateResume(t *testing.T) {
+	assert.NoError(t, SetupState{}.Resume("admin", "dev"))
+	started := SetupState{Status: SetupStarted, Username: "admin", Environment: "dev"}
+	assert.NoError(t, started.Resume("admin", "dev"))
+	assert.Error(t, started.Resume("other", "dev"))
+	assert.Error(t, started.Resume("admin", "prod"))
+	assert.Error(t, SetupState{Status: SetupCompleted}.Resume("admin", "dev"))
+}
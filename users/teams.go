@@ -0,0 +1,107 @@
+package users
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// MaxTeamName is the maximum length of the name of a team
+const MaxTeamName = 64
+
+// Team names use letters, digits and _ . -
+var teamNameRe = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+// TeamMember to record that a user belongs to a team. Teams exist while they have members, and they are
+// used to share the results of queries with restricted visibility.
+type TeamMember struct {
+	gorm.Model
+	Team     string `gorm:"uniqueIndex:idx_team_member"`
+	Username string `gorm:"uniqueIndex:idx_team_member;index"`
+	AddedBy  string
+}
+
+// ValidateTeam to check the name of a team
+func ValidateTeam(team string) error {
+	if len(team) == 0 || len(team) > MaxTeamName || !teamNameRe.MatchString(team) {
+		return fmt.Errorf("invalid team %q, use up to %d letters, digits and _ . -", team, MaxTeamName)
+	}
+	return nil
+}
+
+// GroupTeams to get the members of each team, sorted by username
+func GroupTeams(members []TeamMember) map[string][]string {
+	teams := make(map[string][]string)
+	for _, m := range members {
+		teams[m.Team] = append(teams[m.Team], m.Username)
+	}
+	for t := range teams {
+		sort.Strings(teams[t])
+	}
+	return teams
+}
+
+// AddTeamMember to add an existing user to a team, creating the team if it has no members yet
+func (m *UserManager) AddTeamMember(team, username, addedBy string) error {
+	team = strings.TrimSpace(team)
+	if err := ValidateTeam(team); err != nil {
+		return err
+	}
+	if !m.Exists(username) {
+		return fmt.Errorf("user %s does not exist", username)
+	}
+	var member TeamMember
+	if err := m.DB.Where("team = ? AND username = ?", team, username).Find(&member).Error; err != nil {
+		return fmt.Errorf("Find %v", err)
+	}
+	if member.ID != 0 {
+		return nil
+	}
+	member = TeamMember{Team: team, Username: username, AddedBy: addedBy}
+	if err := m.DB.Create(&member).Error; err != nil {
+		return fmt.Errorf("Create %v", err)
+	}
+	return nil
+}
+
+// RemoveTeamMember to remove a user from a team
+func (m *UserManager) RemoveTeamMember(team, username string) error {
+	res := m.DB.Unscoped().Where("team = ? AND username = ?", team, username).Delete(&TeamMember{})
+	if res.Error != nil {
+		return fmt.Errorf("Delete %v", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// UserTeams to get the teams of a user, sorted by name
+func (m *UserManager) UserTeams(username string) ([]string, error) {
+	var teams []string
+	if err := m.DB.Model(&TeamMember{}).Where("username = ?", username).Order("team").Pluck("team", &teams).Error; err != nil {
+		return teams, err
+	}
+	return teams, nil
+}
+
+// Teams to get all the teams with their members
+func (m *UserManager) Teams() (map[string][]string, error) {
+	var members []TeamMember
+	if err := m.DB.Find(&members).Error; err != nil {
+		return nil, err
+	}
+	return GroupTeams(members), nil
+}
+
+// InTeam to check if a user belongs to a team
+func (m *UserManager) InTeam(team, username string) bool {
+	var count int64
+	if err := m.DB.Model(&TeamMember{}).Where("team = ? AND username = ?", team, username).Count(&count).Error; err != nil {
+		return false
+	}
+	return count > 0
+}
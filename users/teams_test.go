@@ -0,0 +1,26 @@
+package users
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateTeam(t *testing.T) {
+	assert.NoError(t, ValidateTeam("incident-response"))
+	assert.NoError(t, ValidateTeam("soc.tier_2"))
+	assert.Error(t, ValidateTeam(""))
+	assert.Error(t, ValidateTeam("red team"))
+	assert.Error(t, ValidateTeam(strings.Repeat("a", MaxTeamName+1)))
+}
+
+func TestGroupTeams(t *testing.T) {
+	teams := GroupTeams([]TeamMember{
+		{Team: "ir", Username: "bob"},
+		{Team: "soc", Username: "carol"},
+		{Team: "ir", Username: "alice"},
+	})
+	assert.Equal(t, map[string][]string{"ir": {"alice", "bob"}, "soc": {"carol"}}, teams)
+	assert.Empty(t, GroupTeams(nil))
+}
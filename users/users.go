@@ -16,6 +16,9 @@ const (
 	DefaultTokeIssuer = "osctrl"
 )
 
+// Hash compared for unknown usernames, with the same cost as the hashes of passwords
+var unknownUserHash, _ = bcrypt.GenerateFromPassword([]byte("osctrl-unknown-user"), bcrypt.DefaultCost)
+
 // AdminUser to hold all users
 type AdminUser struct {
 	gorm.Model
@@ -64,6 +67,34 @@ func CreateUserManager(backend *gorm.DB, jwtconfig *types.JSONConfigurationJWT)
 	if err := backend.AutoMigrate(&UserPermission{}); err != nil {
 		log.Fatalf("Failed to AutoMigrate table (user_permissions): %v", err)
 	}
+	// table permission_changes
+	if err := backend.AutoMigrate(&PermissionChange{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (permission_changes): %v", err)
+	}
+	// table api_keys
+	if err := backend.AutoMigrate(&APIKey{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (api_keys): %v", err)
+	}
+	// table login_lockouts
+	if err := backend.AutoMigrate(&LoginLockout{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (login_lockouts): %v", err)
+	}
+	// table notices
+	if err := backend.AutoMigrate(&Notice{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (notices): %v", err)
+	}
+	// table notice_acks
+	if err := backend.AutoMigrate(&NoticeAck{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (notice_acks): %v", err)
+	}
+	// table setup_states
+	if err := backend.AutoMigrate(&SetupState{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (setup_states): %v", err)
+	}
+	// table team_members
+	if err := backend.AutoMigrate(&TeamMember{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (team_members): %v", err)
+	}
 	return u
 }
 
@@ -88,6 +119,8 @@ func (m *UserManager) CheckLoginCredentials(username, password string) (bool, Ad
 	// Retrieve user
 	user, err := m.Get(username)
 	if err != nil {
+		// Compare anyway, so unknown usernames take as long as wrong passwords
+		_ = bcrypt.CompareHashAndPassword(unknownUserHash, []byte(password))
 		return false, AdminUser{}
 	}
 	// Check for hash matching
@@ -231,6 +264,10 @@ func (m *UserManager) Delete(username string) error {
 	if err := m.DB.Unscoped().Delete(&user).Error; err != nil {
 		return fmt.Errorf("Delete %v", err)
 	}
+	// Teams are left too, so a new user with the same name does not see what the team sees
+	if err := m.DB.Unscoped().Where("username = ?", username).Delete(&TeamMember{}).Error; err != nil {
+		return fmt.Errorf("Delete teams %v", err)
+	}
 	return nil
 }
 
@@ -48,6 +48,47 @@ func TestUserManager(t *testing.T) {
 		mock.ExpectExec(`CREATE INDEX IF NOT EXISTS .*`).WillReturnResult(sqlmock.NewResult(1, 1))
 		mock.ExpectExec(`CREATE INDEX IF NOT EXISTS .*`).WillReturnResult(sqlmock.NewResult(1, 1))
 
+		mock.ExpectQuery(
+			regexp.QuoteMeta(`SELECT count(*) FROM information_schema.tables WHERE table_schema = CURRENT_SCHEMA() AND table_name = $1 AND table_type = $2`)).WithArgs("permission_changes", "BASE TABLE").WillReturnRows(sqlmock.NewRows([]string{"count(*)"}), sqlmock.NewRows([]string{"RowsAffected"}).AddRow(1).AddRow(1))
+		mock.ExpectExec(`CREATE TABLE "permission_changes" .*`).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`CREATE INDEX IF NOT EXISTS .*`).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`CREATE INDEX IF NOT EXISTS .*`).WillReturnResult(sqlmock.NewResult(1, 1))
+
+		mock.ExpectQuery(
+			regexp.QuoteMeta(`SELECT count(*) FROM information_schema.tables WHERE table_schema = CURRENT_SCHEMA() AND table_name = $1 AND table_type = $2`)).WithArgs("api_keys", "BASE TABLE").WillReturnRows(sqlmock.NewRows([]string{"count(*)"}), sqlmock.NewRows([]string{"RowsAffected"}).AddRow(1).AddRow(1))
+		mock.ExpectExec(`CREATE TABLE "api_keys" .*`).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`CREATE (UNIQUE )?INDEX IF NOT EXISTS .*`).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`CREATE (UNIQUE )?INDEX IF NOT EXISTS .*`).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`CREATE (UNIQUE )?INDEX IF NOT EXISTS .*`).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`CREATE (UNIQUE )?INDEX IF NOT EXISTS .*`).WillReturnResult(sqlmock.NewResult(1, 1))
+
+		mock.ExpectQuery(
+			regexp.QuoteMeta(`SELECT count(*) FROM information_schema.tables WHERE table_schema = CURRENT_SCHEMA() AND table_name = $1 AND table_type = $2`)).WithArgs("login_lockouts", "BASE TABLE").WillReturnRows(sqlmock.NewRows([]string{"count(*)"}), sqlmock.NewRows([]string{"RowsAffected"}).AddRow(1).AddRow(1))
+		mock.ExpectExec(`CREATE TABLE "login_lockouts" .*`).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`CREATE INDEX IF NOT EXISTS .*`).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`CREATE INDEX IF NOT EXISTS .*`).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`CREATE INDEX IF NOT EXISTS .*`).WillReturnResult(sqlmock.NewResult(1, 1))
+
+		mock.ExpectQuery(
+			regexp.QuoteMeta(`SELECT count(*) FROM information_schema.tables WHERE table_schema = CURRENT_SCHEMA() AND table_name = $1 AND table_type = $2`)).WithArgs("notices", "BASE TABLE").WillReturnRows(sqlmock.NewRows([]string{"count(*)"}), sqlmock.NewRows([]string{"RowsAffected"}).AddRow(1).AddRow(1))
+		mock.ExpectExec(`CREATE TABLE "notices" .*`).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`CREATE INDEX IF NOT EXISTS .*`).WillReturnResult(sqlmock.NewResult(1, 1))
+
+		mock.ExpectQuery(
+			regexp.QuoteMeta(`SELECT count(*) FROM information_schema.tables WHERE table_schema = CURRENT_SCHEMA() AND table_name = $1 AND table_type = $2`)).WithArgs("notice_acks", "BASE TABLE").WillReturnRows(sqlmock.NewRows([]string{"count(*)"}), sqlmock.NewRows([]string{"RowsAffected"}).AddRow(1).AddRow(1))
+		mock.ExpectExec(`CREATE TABLE "notice_acks" .*`).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`CREATE (UNIQUE )?INDEX IF NOT EXISTS .*`).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`CREATE (UNIQUE )?INDEX IF NOT EXISTS .*`).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectQuery(
+			regexp.QuoteMeta(`SELECT count(*) FROM information_schema.tables WHERE table_schema = CURRENT_SCHEMA() AND table_name = $1 AND table_type = $2`)).WithArgs("setup_states", "BASE TABLE").WillReturnRows(sqlmock.NewRows([]string{"count(*)"}), sqlmock.NewRows([]string{"RowsAffected"}).AddRow(1).AddRow(1))
+		mock.ExpectExec(`CREATE TABLE "setup_states" .*`).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectQuery(
+			regexp.QuoteMeta(`SELECT count(*) FROM information_schema.tables WHERE table_schema = CURRENT_SCHEMA() AND table_name = $1 AND table_type = $2`)).WithArgs("team_members", "BASE TABLE").WillReturnRows(sqlmock.NewRows([]string{"count(*)"}), sqlmock.NewRows([]string{"RowsAffected"}).AddRow(1).AddRow(1))
+		mock.ExpectExec(`CREATE TABLE "team_members" .*`).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`CREATE (UNIQUE )?INDEX IF NOT EXISTS .*`).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`CREATE (UNIQUE )?INDEX IF NOT EXISTS .*`).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`CREATE (UNIQUE )?INDEX IF NOT EXISTS .*`).WillReturnResult(sqlmock.NewResult(1, 1))
+
 		manager = CreateUserManager(_postgres, &conf)
 
 		assert.NotEqual(t, nil, manager)
@@ -291,6 +332,10 @@ func TestUserManager(t *testing.T) {
 		mock.ExpectExec(
 			regexp.QuoteMeta(`DELETE FROM "admin_users" WHERE "admin_users"."id" = $1`)).WithArgs(1).WillReturnResult(sqlmock.NewResult(1, 1))
 		mock.ExpectCommit()
+		mock.ExpectBegin()
+		mock.ExpectExec(
+			regexp.QuoteMeta(`DELETE FROM "team_members" WHERE username = $1`)).WithArgs("testUser").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
 
 		err := manager.Delete("testUser")
 
@@ -0,0 +1,309 @@
+package utils
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// EncodingGzip for gzip compressed responses
+	EncodingGzip string = "gzip"
+	// EncodingDeflate for deflate compressed responses
+	EncodingDeflate string = "deflate"
+	// DefaultCompressMinSize is the default size in bytes for responses to be compressed
+	DefaultCompressMinSize int = 1024
+)
+
+// AcceptEncoding for header key
+const AcceptEncoding string = "Accept-Encoding"
+
+// ContentEncoding for header key
+const ContentEncoding string = "Content-Encoding"
+
+// Content types that are already compressed, compressing them again only costs CPU
+var compressedTypes = []string{
+	"image/",
+	"video/",
+	"audio/",
+	"font/woff",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"application/zstd",
+	"application/pdf",
+	"application/octet-stream",
+}
+
+// Content types of streams, they are flushed by message and never buffered
+var streamTypes = []string{
+	"text/event-stream",
+	"application/x-ndjson",
+	"application/stream+json",
+}
+
+// CompressConfig to configure the compression of responses
+type CompressConfig struct {
+	// Minimum size in bytes of responses to be compressed, DefaultCompressMinSize if zero
+	MinSize int
+	// Compression level, gzip.DefaultCompression if zero
+	Level int
+	// Compress streams, the compressor is flushed every time the handler flushes
+	Streams bool
+}
+
+// Helper to check if a content type matches any of the prefixes
+func matchType(cType string, prefixes []string) bool {
+	cType = strings.ToLower(strings.TrimSpace(cType))
+	for _, p := range prefixes {
+		if strings.HasPrefix(cType, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// NegotiateEncoding to get the preferred supported encoding from an Accept-Encoding header.
+// It returns an empty string if no supported encoding is acceptable.
+func NegotiateEncoding(header string) string {
+	var best string
+	var bestQ float64
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, f := range fields[1:] {
+			f = strings.TrimSpace(f)
+			if strings.HasPrefix(f, "q=") {
+				if v, err := strconv.ParseFloat(f[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if name == "*" {
+			name = EncodingGzip
+		}
+		if name != EncodingGzip && name != EncodingDeflate {
+			continue
+		}
+		// gzip is preferred with the same weight
+		if q > bestQ || (q == bestQ && name == EncodingGzip) {
+			best, bestQ = name, q
+		}
+	}
+	if bestQ <= 0 {
+		return ""
+	}
+	return best
+}
+
+// compressor to write compressed data, gzip and flate writers both implement it
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+// compressPools to reuse compressors between responses, they are expensive to allocate
+type compressPools struct {
+	gzip  sync.Pool
+	flate sync.Pool
+}
+
+// Helper to create the pools for a compression level
+func newCompressPools(level int) *compressPools {
+	p := &compressPools{}
+	p.gzip.New = func() interface{} {
+		w, _ := gzip.NewWriterLevel(io.Discard, level)
+		return w
+	}
+	p.flate.New = func() interface{} {
+		w, _ := flate.NewWriter(io.Discard, level)
+		return w
+	}
+	return p
+}
+
+// Helper to get a compressor for an encoding, writing to w
+func (p *compressPools) get(encoding string, w io.Writer) compressor {
+	var c compressor
+	if encoding == EncodingGzip {
+		c = p.gzip.Get().(*gzip.Writer)
+	} else {
+		c = p.flate.Get().(*flate.Writer)
+	}
+	c.Reset(w)
+	return c
+}
+
+// Helper to return a compressor to its pool
+func (p *compressPools) put(encoding string, c compressor) {
+	if encoding == EncodingGzip {
+		p.gzip.Put(c)
+	} else {
+		p.flate.Put(c)
+	}
+}
+
+// compressWriter to buffer responses until they are big enough to be compressed
+type compressWriter struct {
+	http.ResponseWriter
+	cfg      CompressConfig
+	pools    *compressPools
+	encoding string
+	status   int
+	buf      []byte
+	decided  bool
+	comp     compressor
+}
+
+// Helper to check if the response can not have a body
+func bodyless(status int) bool {
+	return status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified
+}
+
+// WriteHeader to keep the status code until it is decided if the response is compressed
+func (w *compressWriter) WriteHeader(status int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = status
+	h := w.Header()
+	switch {
+	case bodyless(status), status == http.StatusPartialContent, h.Get(ContentEncoding) != "", h.Get("Content-Range") != "":
+		w.passthrough()
+	case matchType(h.Get(ContentType), compressedTypes):
+		w.passthrough()
+	case matchType(h.Get(ContentType), streamTypes):
+		if w.cfg.Streams {
+			w.compress()
+		} else {
+			w.passthrough()
+		}
+	}
+}
+
+// Write to buffer the response, it is compressed once it reaches the minimum size
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.decided {
+		if w.comp != nil {
+			return w.comp.Write(p)
+		}
+		return w.ResponseWriter.Write(p)
+	}
+	w.buf = append(w.buf, p...)
+	if len(w.buf) >= w.cfg.MinSize {
+		// Same as the standard library would do for responses without content type
+		if w.Header().Get(ContentType) == "" {
+			w.Header().Set(ContentType, http.DetectContentType(w.buf))
+			if matchType(w.Header().Get(ContentType), compressedTypes) {
+				w.passthrough()
+				return len(p), nil
+			}
+		}
+		w.compress()
+	}
+	return len(p), nil
+}
+
+// Helper to send the response as is, with anything buffered
+func (w *compressWriter) passthrough() {
+	w.decided = true
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	if len(w.buf) > 0 {
+		_, _ = w.ResponseWriter.Write(w.buf)
+		w.buf = nil
+	}
+}
+
+// Helper to start compressing the response, with anything buffered
+func (w *compressWriter) compress() {
+	w.decided = true
+	h := w.Header()
+	// Length is not known until the response is complete
+	h.Del("Content-Length")
+	h.Set(ContentEncoding, w.encoding)
+	// The compressed representation is not byte for byte the same, so the validator is weak
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		h.Set("ETag", "W/"+etag)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	w.comp = w.pools.get(w.encoding, w.ResponseWriter)
+	if len(w.buf) > 0 {
+		if _, err := w.comp.Write(w.buf); err != nil {
+			log.Printf("error compressing response %v", err)
+		}
+		w.buf = nil
+	}
+}
+
+// Flush to send anything buffered or compressed so far, streams call it after every message
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		if w.status == 0 {
+			w.WriteHeader(http.StatusOK)
+		}
+		if !w.decided {
+			w.passthrough()
+		}
+	}
+	if w.comp != nil {
+		if err := w.comp.Flush(); err != nil {
+			log.Printf("error flushing compressed response %v", err)
+		}
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close to finish the response, small responses are sent uncompressed
+func (w *compressWriter) Close() {
+	if !w.decided {
+		if w.status == 0 && len(w.buf) == 0 {
+			// Nothing was written, the server sends its default response
+			return
+		}
+		w.passthrough()
+	}
+	if w.comp != nil {
+		if err := w.comp.Close(); err != nil {
+			log.Printf("error closing compressed response %v", err)
+		}
+		w.pools.put(w.encoding, w.comp)
+		w.comp = nil
+	}
+}
+
+// CompressHandler to compress responses with gzip or deflate, as negotiated with Accept-Encoding.
+// Responses smaller than the minimum size, already compressed content and streams are not compressed.
+func CompressHandler(next http.Handler, cfg CompressConfig) http.Handler {
+	if cfg.MinSize <= 0 {
+		cfg.MinSize = DefaultCompressMinSize
+	}
+	if cfg.Level == 0 || cfg.Level < gzip.HuffmanOnly || cfg.Level > gzip.BestCompression {
+		cfg.Level = gzip.DefaultCompression
+	}
+	pools := newCompressPools(cfg.Level)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", AcceptEncoding)
+		encoding := NegotiateEncoding(r.Header.Get(AcceptEncoding))
+		if encoding == "" || r.Method == http.MethodHead || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, cfg: cfg, pools: pools, encoding: encoding}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
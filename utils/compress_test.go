@@ -0,0 +1,239 @@
+package utils
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper to serve a request with compression, returning the recorded response
+func serveCompressed(h http.HandlerFunc, cfg CompressConfig, accept string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if accept != "" {
+		req.Header.Set(AcceptEncoding, accept)
+	}
+	rr := httptest.NewRecorder()
+	CompressHandler(h, cfg).ServeHTTP(rr, req)
+	return rr
+}
+
+// Helper to decompress a response body
+func decompress(t *testing.T, encoding string, body []byte) string {
+	var r io.Reader
+	switch encoding {
+	case EncodingGzip:
+		gr, err := gzip.NewReader(bytes.NewReader(body))
+		assert.NoError(t, err)
+		r = gr
+	case EncodingDeflate:
+		r = flate.NewReader(bytes.NewReader(body))
+	default:
+		return string(body)
+	}
+	res, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	return string(res)
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	assert.Equal(t, "", NegotiateEncoding(""))
+	assert.Equal(t, EncodingGzip, NegotiateEncoding("gzip, deflate, br"))
+	assert.Equal(t, EncodingDeflate, NegotiateEncoding("deflate"))
+	assert.Equal(t, EncodingDeflate, NegotiateEncoding("gzip;q=0.5, deflate;q=0.8"))
+	assert.Equal(t, EncodingGzip, NegotiateEncoding("deflate, gzip"))
+	assert.Equal(t, "", NegotiateEncoding("gzip;q=0, identity"))
+	assert.Equal(t, "", NegotiateEncoding("br, zstd"))
+	assert.Equal(t, EncodingGzip, NegotiateEncoding("*"))
+}
+
+func TestCompressHandler(t *testing.T) {
+	large := strings.Repeat(`{"uuid":"ABCD","hostname":"host"},`, 100)
+	handler := func(body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Length", fmt.Sprintf("%d", len(body)))
+			w.Header().Set("ETag", `"v1"`)
+			HTTPResponse(w, JSONApplicationUTF8, http.StatusOK, []byte(body))
+		}
+	}
+	t.Run("gzip", func(t *testing.T) {
+		rr := serveCompressed(handler(large), CompressConfig{}, "gzip, deflate")
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, EncodingGzip, rr.Header().Get(ContentEncoding))
+		assert.Equal(t, "", rr.Header().Get("Content-Length"))
+		assert.Equal(t, `W/"v1"`, rr.Header().Get("ETag"))
+		assert.Equal(t, AcceptEncoding, rr.Header().Get("Vary"))
+		assert.True(t, rr.Body.Len() < len(large))
+		assert.Equal(t, large, decompress(t, EncodingGzip, rr.Body.Bytes()))
+	})
+	t.Run("deflate", func(t *testing.T) {
+		rr := serveCompressed(handler(large), CompressConfig{}, "deflate")
+		assert.Equal(t, EncodingDeflate, rr.Header().Get(ContentEncoding))
+		assert.Equal(t, large, decompress(t, EncodingDeflate, rr.Body.Bytes()))
+	})
+	t.Run("not accepted", func(t *testing.T) {
+		rr := serveCompressed(handler(large), CompressConfig{}, "")
+		assert.Equal(t, "", rr.Header().Get(ContentEncoding))
+		assert.Equal(t, fmt.Sprintf("%d", len(large)), rr.Header().Get("Content-Length"))
+		assert.Equal(t, `"v1"`, rr.Header().Get("ETag"))
+		assert.Equal(t, AcceptEncoding, rr.Header().Get("Vary"))
+		assert.Equal(t, large, rr.Body.String())
+	})
+	t.Run("below threshold", func(t *testing.T) {
+		rr := serveCompressed(handler(`{"small":true}`), CompressConfig{}, "gzip")
+		assert.Equal(t, "", rr.Header().Get(ContentEncoding))
+		assert.Equal(t, "14", rr.Header().Get("Content-Length"))
+		assert.Equal(t, `"v1"`, rr.Header().Get("ETag"))
+		assert.Equal(t, `{"small":true}`, rr.Body.String())
+	})
+	t.Run("already compressed", func(t *testing.T) {
+		rr := serveCompressed(func(w http.ResponseWriter, r *http.Request) {
+			HTTPResponse(w, "application/zip", http.StatusOK, []byte(large))
+		}, CompressConfig{}, "gzip")
+		assert.Equal(t, "", rr.Header().Get(ContentEncoding))
+		assert.Equal(t, large, rr.Body.String())
+	})
+	t.Run("status kept", func(t *testing.T) {
+		rr := serveCompressed(func(w http.ResponseWriter, r *http.Request) {
+			HTTPResponse(w, JSONApplicationUTF8, http.StatusNotFound, []byte(large))
+		}, CompressConfig{}, "gzip")
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+		assert.Equal(t, large, decompress(t, EncodingGzip, rr.Body.Bytes()))
+	})
+	t.Run("no content", func(t *testing.T) {
+		rr := serveCompressed(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}, CompressConfig{}, "gzip")
+		assert.Equal(t, http.StatusNoContent, rr.Code)
+		assert.Equal(t, "", rr.Header().Get(ContentEncoding))
+		assert.Equal(t, 0, rr.Body.Len())
+	})
+}
+
+func TestCompressHandlerStreams(t *testing.T) {
+	stream := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(ContentType, "application/x-ndjson")
+		for i := 0; i < 3; i++ {
+			fmt.Fprintf(w, "{\"message\":%d}\n", i)
+			w.(http.Flusher).Flush()
+		}
+	}
+	expected := "{\"message\":0}\n{\"message\":1}\n{\"message\":2}\n"
+	// Streams are not buffered nor compressed by default
+	rr := serveCompressed(stream, CompressConfig{}, "gzip")
+	assert.Equal(t, "", rr.Header().Get(ContentEncoding))
+	assert.True(t, rr.Flushed)
+	assert.Equal(t, expected, rr.Body.String())
+	// Compressed streams are flushed by message
+	rr = serveCompressed(stream, CompressConfig{Streams: true}, "gzip")
+	assert.Equal(t, EncodingGzip, rr.Header().Get(ContentEncoding))
+	assert.True(t, rr.Flushed)
+	assert.Equal(t, expected, decompress(t, EncodingGzip, rr.Body.Bytes()))
+}
+
+// benchNode to hold the fields of a node as returned by nodes listings
+type benchNode struct {
+	ID              uint      `json:"ID"`
+	CreatedAt       time.Time `json:"CreatedAt"`
+	UpdatedAt       time.Time `json:"UpdatedAt"`
+	NodeKey         string    `json:"NodeKey"`
+	UUID            string    `json:"UUID"`
+	Platform        string    `json:"Platform"`
+	PlatformVersion string    `json:"PlatformVersion"`
+	OsqueryVersion  string    `json:"OsqueryVersion"`
+	Hostname        string    `json:"Hostname"`
+	Localname       string    `json:"Localname"`
+	IPAddress       string    `json:"IPAddress"`
+	Username        string    `json:"Username"`
+	Environment     string    `json:"Environment"`
+	CPU             string    `json:"CPU"`
+	Memory          string    `json:"Memory"`
+	HardwareSerial  string    `json:"HardwareSerial"`
+	BytesReceived   int       `json:"BytesReceived"`
+	LastStatus      time.Time `json:"LastStatus"`
+	LastResult      time.Time `json:"LastResult"`
+	LastConfig      time.Time `json:"LastConfig"`
+}
+
+// Helper to generate a nodes listing for benchmarks
+func benchNodesListing(n int) []byte {
+	now := time.Now()
+	nodes := make([]benchNode, n)
+	for i := range nodes {
+		nodes[i] = benchNode{
+			ID:              uint(i),
+			CreatedAt:       now.Add(-time.Duration(i) * time.Hour),
+			UpdatedAt:       now,
+			NodeKey:         fmt.Sprintf("%040x", i*7919),
+			UUID:            fmt.Sprintf("%08X-1A2B-3C4D-5E6F-%012X", i, i*31),
+			Platform:        []string{"darwin", "ubuntu", "windows", "centos"}[i%4],
+			PlatformVersion: fmt.Sprintf("%d.%d", 10+i%4, i%10),
+			OsqueryVersion:  "5.7.0",
+			Hostname:        fmt.Sprintf("host-%05d.example.com", i),
+			Localname:       fmt.Sprintf("host-%05d", i),
+			IPAddress:       fmt.Sprintf("10.%d.%d.%d", i/65536%256, i/256%256, i%256),
+			Username:        fmt.Sprintf("user%d", i%500),
+			Environment:     "production",
+			CPU:             "Intel(R) Core(TM) i7-8850H CPU @ 2.60GHz",
+			Memory:          "17179869184",
+			HardwareSerial:  fmt.Sprintf("C02X%06d", i),
+			BytesReceived:   i * 1024,
+			LastStatus:      now.Add(-time.Duration(i%60) * time.Minute),
+			LastResult:      now.Add(-time.Duration(i%30) * time.Minute),
+			LastConfig:      now.Add(-time.Duration(i%5) * time.Minute),
+		}
+	}
+	listing, _ := json.Marshal(nodes)
+	return listing
+}
+
+// countingWriter to count the bytes sent on the wire, discarding them
+type countingWriter struct {
+	header http.Header
+	bytes  int
+}
+
+func (c *countingWriter) Header() http.Header         { return c.header }
+func (c *countingWriter) WriteHeader(int)             {}
+func (c *countingWriter) Write(p []byte) (int, error) { c.bytes += len(p); return len(p), nil }
+
+// Benchmark for a listing of 5000 nodes, reports the bytes on the wire and the ratio to the uncompressed size
+func benchmarkNodesListing(b *testing.B, accept string, level int) {
+	listing := benchNodesListing(5000)
+	handler := CompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		HTTPResponse(w, JSONApplicationUTF8, http.StatusOK, listing)
+	}), CompressConfig{Level: level})
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/nodes/production/all", nil)
+	if accept != "" {
+		req.Header.Set(AcceptEncoding, accept)
+	}
+	var wire int
+	b.SetBytes(int64(len(listing)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w := &countingWriter{header: make(http.Header)}
+		handler.ServeHTTP(w, req)
+		wire = w.bytes
+	}
+	b.ReportMetric(float64(wire), "wire-bytes")
+	b.ReportMetric(float64(wire)/float64(len(listing)), "wire-ratio")
+}
+
+func BenchmarkNodesListingIdentity(b *testing.B) { benchmarkNodesListing(b, "", 0) }
+
+func BenchmarkNodesListingGzip(b *testing.B) { benchmarkNodesListing(b, EncodingGzip, 0) }
+
+func BenchmarkNodesListingGzipFast(b *testing.B) {
+	benchmarkNodesListing(b, EncodingGzip, gzip.BestSpeed)
+}
+
+func BenchmarkNodesListingDeflate(b *testing.B) { benchmarkNodesListing(b, EncodingDeflate, 0) }
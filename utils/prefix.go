@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"net/http"
+	"strings"
+)
+
+// NormalizeURLPrefix to get a URL path prefix with a leading slash and without trailing slash.
+// An empty prefix or / means the service is served from the root.
+func NormalizeURLPrefix(prefix string) string {
+	prefix = strings.Trim(strings.TrimSpace(prefix), "/")
+	if prefix == "" {
+		return ""
+	}
+	return "/" + prefix
+}
+
+// CookiePath to get the path of cookies of a service served under a URL path prefix
+func CookiePath(prefix string) string {
+	if prefix == "" {
+		return "/"
+	}
+	return prefix + "/"
+}
+
+// URLPrefixHandler to serve a handler under a URL path prefix, removing the prefix before routing.
+// Requests to the prefix without trailing slash are redirected and anything outside the prefix is not found.
+// Without prefix the handler is returned as is.
+func URLPrefixHandler(prefix string, h http.Handler) http.Handler {
+	if prefix == "" {
+		return h
+	}
+	stripped := http.StripPrefix(prefix, h)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == prefix {
+			http.Redirect(w, r, prefix+"/", http.StatusFound)
+			return
+		}
+		if !strings.HasPrefix(r.URL.Path, prefix+"/") {
+			http.NotFound(w, r)
+			return
+		}
+		stripped.ServeHTTP(w, r)
+	})
+}
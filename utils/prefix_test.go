@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeURLPrefix(t *testing.T) {
+	assert.Equal(t, "", NormalizeURLPrefix(""))
+	assert.Equal(t, "", NormalizeURLPrefix("/"))
+	assert.Equal(t, "/osctrl", NormalizeURLPrefix("osctrl/"))
+	assert.Equal(t, "/osctrl/admin", NormalizeURLPrefix(" /osctrl/admin/ "))
+	assert.Equal(t, "/", CookiePath(""))
+	assert.Equal(t, "/osctrl/", CookiePath("/osctrl"))
+}
+
+func TestURLPrefixHandler(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		HTTPResponse(w, TextPlainUTF8, http.StatusOK, []byte(r.URL.Path))
+	})
+	serve := func(prefix, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		URLPrefixHandler(prefix, h).ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+	// Without prefix requests are routed as they are
+	w := serve("", "/json/tags")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "/json/tags", w.Body.String())
+	// The prefix is removed before routing
+	w = serve("/osctrl", "/osctrl/json/tags")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "/json/tags", w.Body.String())
+	// Anything outside the prefix is not found, the prefix itself redirects
+	assert.Equal(t, http.StatusNotFound, serve("/osctrl", "/json/tags").Code)
+	assert.Equal(t, http.StatusNotFound, serve("/osctrl", "/osctrlx/json/tags").Code)
+	w = serve("/osctrl", "/osctrl")
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "/osctrl/", w.Header().Get("Location"))
+}
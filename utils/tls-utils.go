@@ -0,0 +1,255 @@
+package utils
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+const (
+	// TLSProfileLegacy keeps the parameters used before profiles existed, with CBC and RSA key exchange suites
+	TLSProfileLegacy string = "legacy"
+	// TLSProfileModern for TLS 1.3 only
+	TLSProfileModern string = "modern"
+	// TLSProfileIntermediate for TLS 1.2 and 1.3 with forward secrecy and AEAD suites
+	TLSProfileIntermediate string = "intermediate"
+	// TLSProfileFIPS for TLS 1.2 with FIPS 140-2 approved suites and curves
+	TLSProfileFIPS string = "fips"
+	// DefaultTLSProfile is the profile used when none is provided
+	DefaultTLSProfile string = TLSProfileLegacy
+)
+
+// TLSProfiles to list all the valid TLS profiles
+var TLSProfiles = []string{TLSProfileLegacy, TLSProfileModern, TLSProfileIntermediate, TLSProfileFIPS}
+
+// TLSOptions to configure the TLS listener of a service, with a profile and overrides of its values.
+// Empty values keep the values of the profile.
+type TLSOptions struct {
+	Profile               string
+	MinVersion            string
+	MaxVersion            string
+	CipherSuites          []string
+	Curves                []string
+	DisableSessionTickets bool
+}
+
+// TLS versions by name
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Curves by name
+var tlsCurves = map[string]tls.CurveID{
+	"X25519": tls.X25519,
+	"P256":   tls.CurveP256,
+	"P384":   tls.CurveP384,
+	"P521":   tls.CurveP521,
+}
+
+// TLS 1.3 suites, always enabled by Go when TLS 1.3 is negotiated
+var tls13Suites = []uint16{
+	tls.TLS_AES_128_GCM_SHA256,
+	tls.TLS_AES_256_GCM_SHA384,
+	tls.TLS_CHACHA20_POLY1305_SHA256,
+}
+
+// Helper to get the configuration of a TLS profile
+func tlsProfile(profile string) (*tls.Config, error) {
+	switch profile {
+	case TLSProfileLegacy:
+		return &tls.Config{
+			MinVersion:       tls.VersionTLS12,
+			CurvePreferences: []tls.CurveID{tls.CurveP521, tls.CurveP384, tls.CurveP256},
+			CipherSuites: []uint16{
+				tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+				tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+				tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+				tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+			},
+		}, nil
+	case TLSProfileModern:
+		return &tls.Config{
+			MinVersion:       tls.VersionTLS13,
+			MaxVersion:       tls.VersionTLS13,
+			CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384},
+		}, nil
+	case TLSProfileIntermediate:
+		return &tls.Config{
+			MinVersion:       tls.VersionTLS12,
+			MaxVersion:       tls.VersionTLS13,
+			CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384},
+			CipherSuites: []uint16{
+				tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+				tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+				tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+				tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+				tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+				tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+			},
+		}, nil
+	case TLSProfileFIPS:
+		// TLS 1.3 is disabled because Go does not allow to restrict its suites to the approved ones
+		return &tls.Config{
+			MinVersion:       tls.VersionTLS12,
+			MaxVersion:       tls.VersionTLS12,
+			CurvePreferences: []tls.CurveID{tls.CurveP256, tls.CurveP384},
+			CipherSuites: []uint16{
+				tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+				tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+				tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+				tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			},
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown TLS profile %q, valid profiles are %s", profile, strings.Join(TLSProfiles, ", "))
+}
+
+// Helper to get a TLS version by name
+func tlsVersion(name string) (uint16, error) {
+	v, ok := tlsVersions[strings.TrimSpace(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version %q, valid versions are 1.0, 1.1, 1.2 and 1.3", name)
+	}
+	return v, nil
+}
+
+// Helper to get the name of a TLS version
+func tlsVersionName(version uint16) string {
+	for name, v := range tlsVersions {
+		if v == version {
+			return name
+		}
+	}
+	return fmt.Sprintf("0x%04x", version)
+}
+
+// Helper to get a cipher suite by name, from the secure and the insecure suites of Go
+func tlsCipherSuite(name string) (uint16, error) {
+	name = strings.TrimSpace(name)
+	for _, s := range tls.CipherSuites() {
+		if s.Name == name {
+			return s.ID, nil
+		}
+	}
+	for _, s := range tls.InsecureCipherSuites() {
+		if s.Name == name {
+			return s.ID, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown TLS cipher suite %q", name)
+}
+
+// Helper to check if a suite is a TLS 1.3 suite
+func isTLS13Suite(id uint16) bool {
+	for _, s := range tls13Suites {
+		if s == id {
+			return true
+		}
+	}
+	return false
+}
+
+// TLSConfig to prepare the configuration of a TLS listener, from a profile and its overrides.
+// Unknown profiles, versions, cipher suites or curves are rejected. TLS 1.3 suites are always
+// enabled by Go with TLS 1.3, so they can be listed only all together and with TLS 1.3 enabled.
+func TLSConfig(opts TLSOptions) (*tls.Config, error) {
+	profile := opts.Profile
+	if profile == "" {
+		profile = DefaultTLSProfile
+	}
+	cfg, err := tlsProfile(profile)
+	if err != nil {
+		return nil, err
+	}
+	if opts.MinVersion != "" {
+		if cfg.MinVersion, err = tlsVersion(opts.MinVersion); err != nil {
+			return nil, err
+		}
+	}
+	if opts.MaxVersion != "" {
+		if cfg.MaxVersion, err = tlsVersion(opts.MaxVersion); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.MaxVersion != 0 && cfg.MaxVersion < cfg.MinVersion {
+		return nil, fmt.Errorf("TLS max version %s is lower than min version %s", tlsVersionName(cfg.MaxVersion), tlsVersionName(cfg.MinVersion))
+	}
+	if len(opts.CipherSuites) > 0 {
+		var suites []uint16
+		tls13 := 0
+		for _, name := range opts.CipherSuites {
+			id, err := tlsCipherSuite(name)
+			if err != nil {
+				return nil, err
+			}
+			if isTLS13Suite(id) {
+				tls13++
+				continue
+			}
+			suites = append(suites, id)
+		}
+		if tls13 > 0 && tls13 != len(tls13Suites) {
+			return nil, fmt.Errorf("TLS 1.3 cipher suites can not be selected, list all of them or none")
+		}
+		if tls13 > 0 && cfg.MaxVersion != 0 && cfg.MaxVersion < tls.VersionTLS13 {
+			return nil, fmt.Errorf("TLS 1.3 cipher suites listed with TLS 1.3 disabled")
+		}
+		if len(suites) == 0 && cfg.MinVersion < tls.VersionTLS13 {
+			return nil, fmt.Errorf("no cipher suites listed for TLS %s", tlsVersionName(cfg.MinVersion))
+		}
+		cfg.CipherSuites = suites
+	}
+	if len(opts.Curves) > 0 {
+		var curves []tls.CurveID
+		for _, name := range opts.Curves {
+			c, ok := tlsCurves[strings.TrimSpace(name)]
+			if !ok {
+				return nil, fmt.Errorf("unknown TLS curve %q, valid curves are X25519, P256, P384 and P521", name)
+			}
+			curves = append(curves, c)
+		}
+		cfg.CurvePreferences = curves
+	}
+	cfg.SessionTicketsDisabled = opts.DisableSessionTickets
+	cfg.PreferServerCipherSuites = true
+	return cfg, nil
+}
+
+// TLSSummary to describe the effective configuration of a TLS listener, to be logged at startup
+func TLSSummary(profile string, cfg *tls.Config) string {
+	if profile == "" {
+		profile = DefaultTLSProfile
+	}
+	maxVersion := cfg.MaxVersion
+	if maxVersion == 0 {
+		maxVersion = tls.VersionTLS13
+	}
+	var suites []string
+	if cfg.MinVersion < tls.VersionTLS13 {
+		for _, s := range cfg.CipherSuites {
+			suites = append(suites, tls.CipherSuiteName(s))
+		}
+	}
+	if maxVersion >= tls.VersionTLS13 {
+		for _, s := range tls13Suites {
+			suites = append(suites, tls.CipherSuiteName(s))
+		}
+	}
+	var curves []string
+	for _, c := range cfg.CurvePreferences {
+		for name, id := range tlsCurves {
+			if id == c {
+				curves = append(curves, name)
+			}
+		}
+	}
+	tickets := "enabled"
+	if cfg.SessionTicketsDisabled {
+		tickets = "disabled"
+	}
+	return fmt.Sprintf("profile %s, versions %s-%s, suites %s, curves %s, session tickets %s",
+		profile, tlsVersionName(cfg.MinVersion), tlsVersionName(maxVersion), strings.Join(suites, ","), strings.Join(curves, ","), tickets)
+}
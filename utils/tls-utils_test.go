@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTLSConfigLegacy(t *testing.T) {
+	cfg, err := TLSConfig(TLSOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS12), cfg.MinVersion)
+	assert.Equal(t, uint16(0), cfg.MaxVersion)
+	assert.Equal(t, []tls.CurveID{tls.CurveP521, tls.CurveP384, tls.CurveP256}, cfg.CurvePreferences)
+	assert.Equal(t, []uint16{
+		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+		tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+	}, cfg.CipherSuites)
+	assert.True(t, cfg.PreferServerCipherSuites)
+	assert.False(t, cfg.SessionTicketsDisabled)
+}
+
+func TestTLSConfigModern(t *testing.T) {
+	cfg, err := TLSConfig(TLSOptions{Profile: TLSProfileModern})
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), cfg.MinVersion)
+	assert.Equal(t, uint16(tls.VersionTLS13), cfg.MaxVersion)
+	assert.Equal(t, []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384}, cfg.CurvePreferences)
+	assert.Empty(t, cfg.CipherSuites)
+}
+
+func TestTLSConfigIntermediate(t *testing.T) {
+	cfg, err := TLSConfig(TLSOptions{Profile: TLSProfileIntermediate})
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS12), cfg.MinVersion)
+	assert.Equal(t, uint16(tls.VersionTLS13), cfg.MaxVersion)
+	assert.Equal(t, []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384}, cfg.CurvePreferences)
+	assert.Len(t, cfg.CipherSuites, 6)
+	for _, s := range cfg.CipherSuites {
+		assert.NotContains(t, tls.CipherSuiteName(s), "CBC")
+	}
+}
+
+func TestTLSConfigFIPS(t *testing.T) {
+	cfg, err := TLSConfig(TLSOptions{Profile: TLSProfileFIPS})
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS12), cfg.MinVersion)
+	assert.Equal(t, uint16(tls.VersionTLS12), cfg.MaxVersion)
+	assert.Equal(t, []tls.CurveID{tls.CurveP256, tls.CurveP384}, cfg.CurvePreferences)
+	assert.Equal(t, []uint16{
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	}, cfg.CipherSuites)
+}
+
+func TestTLSConfigOverrides(t *testing.T) {
+	cfg, err := TLSConfig(TLSOptions{
+		Profile:               TLSProfileIntermediate,
+		MaxVersion:            "1.2",
+		CipherSuites:          []string{"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"},
+		Curves:                []string{"P384"},
+		DisableSessionTickets: true,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS12), cfg.MaxVersion)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}, cfg.CipherSuites)
+	assert.Equal(t, []tls.CurveID{tls.CurveP384}, cfg.CurvePreferences)
+	assert.True(t, cfg.SessionTicketsDisabled)
+	// All TLS 1.3 suites can be listed with TLS 1.3 enabled
+	cfg, err = TLSConfig(TLSOptions{
+		Profile:      TLSProfileIntermediate,
+		CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_AES_128_GCM_SHA256", "TLS_AES_256_GCM_SHA384", "TLS_CHACHA20_POLY1305_SHA256"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}, cfg.CipherSuites)
+}
+
+func TestTLSConfigErrors(t *testing.T) {
+	_, err := TLSConfig(TLSOptions{Profile: "paranoid"})
+	assert.EqualError(t, err, `unknown TLS profile "paranoid", valid profiles are legacy, modern, intermediate, fips`)
+	_, err = TLSConfig(TLSOptions{CipherSuites: []string{"TLS_RSA_WITH_AES_512_GCM"}})
+	assert.EqualError(t, err, `unknown TLS cipher suite "TLS_RSA_WITH_AES_512_GCM"`)
+	_, err = TLSConfig(TLSOptions{MinVersion: "1.4"})
+	assert.Error(t, err)
+	_, err = TLSConfig(TLSOptions{MinVersion: "1.3", MaxVersion: "1.2"})
+	assert.Error(t, err)
+	_, err = TLSConfig(TLSOptions{Curves: []string{"P224"}})
+	assert.Error(t, err)
+	_, err = TLSConfig(TLSOptions{Profile: TLSProfileIntermediate, CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_AES_128_GCM_SHA256"}})
+	assert.Error(t, err)
+	_, err = TLSConfig(TLSOptions{Profile: TLSProfileFIPS, CipherSuites: []string{"TLS_AES_128_GCM_SHA256", "TLS_AES_256_GCM_SHA384", "TLS_CHACHA20_POLY1305_SHA256"}})
+	assert.Error(t, err)
+}
+
+func TestTLSSummary(t *testing.T) {
+	cfg, err := TLSConfig(TLSOptions{Profile: TLSProfileFIPS, DisableSessionTickets: true})
+	assert.NoError(t, err)
+	assert.Equal(t, "profile fips, versions 1.2-1.2, suites TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384, curves P256,P384, session tickets disabled", TLSSummary(TLSProfileFIPS, cfg))
+	cfg, err = TLSConfig(TLSOptions{Profile: TLSProfileModern})
+	assert.NoError(t, err)
+	assert.Equal(t, "profile modern, versions 1.3-1.3, suites TLS_AES_128_GCM_SHA256,TLS_AES_256_GCM_SHA384,TLS_CHACHA20_POLY1305_SHA256, curves X25519,P256,P384, session tickets enabled", TLSSummary(TLSProfileModern, cfg))
+}
@@ -0,0 +1,84 @@
+package version
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+const (
+	// SchemaVersion of the database schema the binaries expect, to increase with changes to the tables
+	SchemaVersion = 1
+	// Unknown for build metadata not injected at build time
+	Unknown = "unknown"
+	// ShortCommitLength is the length of the short hash of the commit
+	ShortCommitLength = 7
+)
+
+// Build metadata injected at build time with ldflags, for example:
+// -ldflags "-X github.com/jmpsec/osctrl/version.GitCommit=$(git rev-parse HEAD)"
+var (
+	// GitCommit of the source code of the build
+	GitCommit = ""
+	// BuildDate of the build, in RFC3339
+	BuildDate = ""
+)
+
+// BuildInfo to describe the build of a service, safe to expose without authentication
+type BuildInfo struct {
+	Service       string `json:"service"`
+	Version       string `json:"version"`
+	Commit        string `json:"commit"`
+	BuildDate     string `json:"build_date"`
+	GoVersion     string `json:"go_version"`
+	SchemaVersion int    `json:"schema_version"`
+}
+
+// Helper to get the commit from the VCS data embedded by the go tool, when it was not injected
+func vcsCommit() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" {
+			return s.Value
+		}
+	}
+	return ""
+}
+
+// Info to get the build metadata of a service
+func Info(service string) BuildInfo {
+	b := BuildInfo{
+		Service:       service,
+		Version:       OsctrlVersion,
+		Commit:        GitCommit,
+		BuildDate:     BuildDate,
+		GoVersion:     runtime.Version(),
+		SchemaVersion: SchemaVersion,
+	}
+	if b.Commit == "" {
+		b.Commit = vcsCommit()
+	}
+	if b.Commit == "" {
+		b.Commit = Unknown
+	}
+	if b.BuildDate == "" {
+		b.BuildDate = Unknown
+	}
+	return b
+}
+
+// ShortCommit to get the short hash of the commit of a build
+func (b BuildInfo) ShortCommit() string {
+	if len(b.Commit) > ShortCommitLength && b.Commit != Unknown {
+		return b.Commit[:ShortCommitLength]
+	}
+	return b.Commit
+}
+
+// String to describe a build in one line, used when services start
+func (b BuildInfo) String() string {
+	return fmt.Sprintf("%s v%s (commit %s, built %s, %s, schema %d)", b.Service, b.Version, b.ShortCommit(), b.BuildDate, b.GoVersion, b.SchemaVersion)
+}
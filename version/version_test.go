@@ -13,3 +13,25 @@ func TestOsqueryVersion(t *testing.T) {
 func TestOsctrlVersion(t *testing.T) {
 	assert.Equal(t, "0.3.1", OsctrlVersion)
 }
+
+func TestInfo(t *testing.T) {
+	GitCommit = "0123456789abcdef"
+	BuildDate = "2023-01-02T03:04:05Z"
+	defer func() {
+		GitCommit = ""
+		BuildDate = ""
+	}()
+	b := Info("osctrl-tls")
+	assert.Equal(t, "osctrl-tls", b.Service)
+	assert.Equal(t, OsctrlVersion, b.Version)
+	assert.Equal(t, "0123456", b.ShortCommit())
+	assert.Equal(t, SchemaVersion, b.SchemaVersion)
+	assert.Equal(t, "osctrl-tls v"+OsctrlVersion+" (commit 0123456, built 2023-01-02T03:04:05Z, "+b.GoVersion+", schema 1)", b.String())
+}
+
+func TestInfoUnknown(t *testing.T) {
+	b := Info("osctrl-api")
+	assert.Equal(t, Unknown, b.BuildDate)
+	assert.NotEmpty(t, b.Commit)
+	assert.NotEmpty(t, b.GoVersion)
+}
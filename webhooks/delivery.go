@@ -0,0 +1,199 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultMaxAttempts is the default number of attempts of a delivery before it is a dead letter
+	DefaultMaxAttempts = 8
+	// DefaultDeliveryInterval to check for pending deliveries
+	DefaultDeliveryInterval = 10 * time.Second
+	// DefaultDeliveryBatch is the maximum number of deliveries attempted in one check
+	DefaultDeliveryBatch = 100
+	// Backoff after the first failed attempt, doubled after every attempt up to the maximum
+	backoffBase = 30 * time.Second
+	backoffMax  = 2 * time.Hour
+	// Timeout of every attempt
+	deliveryTimeout = 10 * time.Second
+	// Maximum size of the response body kept as error
+	maxErrorBody = 512
+)
+
+// HTTPClient to send deliveries, like http.Client
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Helper to get the client used by default, without following redirects so deliveries go where they are configured
+func defaultClient() *http.Client {
+	return &http.Client{
+		Timeout: deliveryTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// Leader to elect the instance that sends deliveries, like the leader locks of the backend
+type Leader interface {
+	Acquire() (bool, error)
+}
+
+// Backoff to get how long to wait before the next attempt, after a number of failed attempts
+func Backoff(attempts int) time.Duration {
+	wait := backoffBase
+	for i := 1; i < attempts; i++ {
+		wait *= 2
+		if wait >= backoffMax {
+			return backoffMax
+		}
+	}
+	return wait
+}
+
+// Post to send the body of an event to an endpoint, signed with its secret at a time.
+// It returns the status code of the response, and an error if the endpoint did not accept it.
+func Post(client HTTPClient, e WebhookEndpoint, eventID, eventType string, body []byte, now time.Time) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, e.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("NewRequest %v", err)
+	}
+	timestamp := now.Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(e.Secret, timestamp, body))
+	req.Header.Set(HeaderEvent, eventType)
+	req.Header.Set(HeaderDelivery, eventID)
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return resp.StatusCode, fmt.Errorf("endpoint returned %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	return resp.StatusCode, nil
+}
+
+// Helper to attempt one delivery, recording the attempt and scheduling the next one if it failed
+func (m *WebhookManager) attempt(e WebhookEndpoint, d WebhookDelivery, now time.Time) (WebhookDelivery, error) {
+	started := time.Now()
+	var code int
+	var err error
+	if !e.Enabled {
+		err = fmt.Errorf("endpoint %s is disabled", e.Name)
+	} else {
+		code, err = Post(m.Client, e, d.EventID, d.EventType, []byte(d.Payload), now)
+	}
+	d.Attempts++
+	d.StatusCode = code
+	d.Error = ""
+	updates := map[string]interface{}{"attempts": d.Attempts, "status_code": code, "error": ""}
+	switch {
+	case err == nil:
+		d.State, d.DeliveredAt = StateDelivered, now
+		updates["state"], updates["delivered_at"] = StateDelivered, now
+	case !e.Enabled || d.Attempts >= m.MaxAttempts:
+		d.State, d.Error = StateFailed, err.Error()
+		updates["state"], updates["error"] = StateFailed, d.Error
+	default:
+		d.Error, d.NextAttempt = err.Error(), now.Add(Backoff(d.Attempts))
+		updates["error"], updates["next_attempt"] = d.Error, d.NextAttempt
+	}
+	if err := m.DB.Model(&d).Updates(updates).Error; err != nil {
+		return d, fmt.Errorf("Updates %v", err)
+	}
+	a := WebhookAttempt{
+		DeliveryID:    d.ID,
+		EndpointID:    d.EndpointID,
+		Endpoint:      d.Endpoint,
+		EnvironmentID: d.EnvironmentID,
+		EventID:       d.EventID,
+		EventType:     d.EventType,
+		EntityType:    d.EntityType,
+		EntityID:      d.EntityID,
+		Attempt:       d.Attempts,
+		StatusCode:    code,
+		Error:         d.Error,
+		Duration:      time.Since(started).Milliseconds(),
+	}
+	if err := m.DB.Create(&a).Error; err != nil {
+		return d, fmt.Errorf("Create %v", err)
+	}
+	return d, nil
+}
+
+// DeliverPending to attempt the deliveries due at a given time, returning how many were delivered
+func (m *WebhookManager) DeliverPending(now time.Time, limit int) (int, error) {
+	var pending []WebhookDelivery
+	if err := m.DB.Where("state = ? AND next_attempt <= ?", StatePending, now).Order("next_attempt").Limit(limit).Find(&pending).Error; err != nil {
+		return 0, fmt.Errorf("Find %v", err)
+	}
+	endpoints := make(map[uint]WebhookEndpoint)
+	delivered := 0
+	for _, d := range pending {
+		e, ok := endpoints[d.EndpointID]
+		if !ok {
+			if err := m.DB.Unscoped().Where("id = ?", d.EndpointID).First(&e).Error; err != nil {
+				log.Printf("error getting webhook endpoint %d %v", d.EndpointID, err)
+				continue
+			}
+			// Deliveries of removed endpoints are not attempted
+			e.Enabled = e.Enabled && !e.DeletedAt.Valid
+			endpoints[d.EndpointID] = e
+		}
+		done, err := m.attempt(e, d, now)
+		if err != nil {
+			return delivered, err
+		}
+		if done.State == StateDelivered {
+			delivered++
+		}
+	}
+	return delivered, nil
+}
+
+// StartDelivery to send pending deliveries periodically, only by the instance holding the leader lock,
+// so each attempt is made once
+func (m *WebhookManager) StartDelivery(ctx context.Context, leader Leader, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultDeliveryInterval
+	}
+	run := func() {
+		if leader != nil {
+			isLeader, err := leader.Acquire()
+			if err != nil {
+				log.Printf("error acquiring leader lock %v", err)
+				return
+			}
+			if !isLeader {
+				return
+			}
+		}
+		if _, err := m.DeliverPending(time.Now(), DefaultDeliveryBatch); err != nil {
+			log.Printf("error delivering webhooks %v", err)
+		}
+	}
+	go func() {
+		run()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				run()
+			}
+		}
+	}()
+}
@@ -0,0 +1,11 @@
+module webhooks
+
+go 1.17
+
+replace github.com/jmpsec/osctrl/environments => ../environments
+
+require (
+	github.com/jmpsec/osctrl/environments v0.3.1
+	github.com/stretchr/testify v1.8.1
+	gorm.io/gorm v1.24.3
+)
@@ -0,0 +1,54 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// HeaderSignature with the HMAC-SHA256 of the timestamp and the body, as sha256=<hex>
+	HeaderSignature = "X-Osctrl-Signature"
+	// HeaderTimestamp with the unix time in seconds when the delivery was attempted
+	HeaderTimestamp = "X-Osctrl-Timestamp"
+	// HeaderEvent with the type of the event
+	HeaderEvent = "X-Osctrl-Event"
+	// HeaderDelivery with the ID of the event, the same for all the attempts of a delivery
+	HeaderDelivery = "X-Osctrl-Delivery"
+	// Prefix of the signature with the algorithm used
+	signaturePrefix = "sha256="
+	// DefaultTolerance is how old a timestamp can be for consumers to accept a delivery
+	DefaultTolerance = 5 * time.Minute
+)
+
+// Sign to get the signature of a body sent at a timestamp. The signed message is the timestamp in seconds,
+// a dot and the raw body, so a captured body can not be replayed with a newer timestamp.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify to check the signature and the timestamp headers of a delivery, like consumers must do
+func Verify(secret, timestamp, signature string, body []byte, now time.Time, tolerance time.Duration) error {
+	ts, err := strconv.ParseInt(strings.TrimSpace(timestamp), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp %q", timestamp)
+	}
+	if age := now.Sub(time.Unix(ts, 0)); age > tolerance || age < -tolerance {
+		return fmt.Errorf("timestamp %d is outside the tolerance", ts)
+	}
+	if !strings.HasPrefix(signature, signaturePrefix) {
+		return fmt.Errorf("invalid signature algorithm")
+	}
+	if !hmac.Equal([]byte(signature), []byte(Sign(secret, ts, body))) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
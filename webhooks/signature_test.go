@@ -0,0 +1,53 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// signatureFixtures documents the signature scheme for integrators, with vectors they can check against
+type signatureFixtures struct {
+	Scheme  string `json:"scheme"`
+	Secret  string `json:"secret"`
+	Vectors []struct {
+		Timestamp int64  `json:"timestamp"`
+		Body      string `json:"body"`
+		Signature string `json:"signature"`
+	} `json:"vectors"`
+}
+
+func TestSignFixtures(t *testing.T) {
+	raw, err := os.ReadFile("testdata/signatures.json")
+	assert.NoError(t, err)
+	var fixtures signatureFixtures
+	assert.NoError(t, json.Unmarshal(raw, &fixtures))
+	assert.NotEmpty(t, fixtures.Vectors)
+	for _, v := range fixtures.Vectors {
+		assert.Equal(t, v.Signature, Sign(fixtures.Secret, v.Timestamp, []byte(v.Body)))
+		ts := strconv.FormatInt(v.Timestamp, 10)
+		assert.NoError(t, Verify(fixtures.Secret, ts, v.Signature, []byte(v.Body), time.Unix(v.Timestamp, 0).Add(time.Minute), DefaultTolerance))
+	}
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1717000000, 0)
+	body := []byte(`{"type":"webhook.test"}`)
+	sig := Sign("secret", now.Unix(), body)
+	ts := strconv.FormatInt(now.Unix(), 10)
+	assert.NoError(t, Verify("secret", ts, sig, body, now, DefaultTolerance))
+	// Other secret or body
+	assert.Error(t, Verify("other", ts, sig, body, now, DefaultTolerance))
+	assert.Error(t, Verify("secret", ts, sig, []byte(`{"type":"query.completed"}`), now, DefaultTolerance))
+	// Replayed with another timestamp
+	assert.Error(t, Verify("secret", strconv.FormatInt(now.Unix()+1, 10), sig, body, now, DefaultTolerance))
+	// Replayed later
+	assert.Error(t, Verify("secret", ts, sig, body, now.Add(DefaultTolerance+time.Second), DefaultTolerance))
+	// Invalid headers
+	assert.Error(t, Verify("secret", "yesterday", sig, body, now, DefaultTolerance))
+	assert.Error(t, Verify("secret", ts, sig[len("sha256="):], body, now, DefaultTolerance))
+}
@@ -0,0 +1,534 @@
+package webhooks
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmpsec/osctrl/environments"
+	"gorm.io/gorm"
+)
+
+const (
+	// EventQueryCompleted when an on-demand query or carve query is completed
+	EventQueryCompleted string = "query.completed"
+	// EventCarveCompleted when all the blocks of a carve are received
+	EventCarveCompleted string = "carve.completed"
+	// EventResponseFired when a detection rule fires a response action
+	EventResponseFired string = "response.fired"
+	// EventTest for deliveries fired by users to test an endpoint
+	EventTest string = "webhook.test"
+)
+
+// Events to list all the kinds of events endpoints can subscribe to
+var Events = []string{EventQueryCompleted, EventCarveCompleted, EventResponseFired}
+
+const (
+	// EntityQuery for events about queries, by name
+	EntityQuery string = "query"
+	// EntityCarve for events about carves, by carve ID
+	EntityCarve string = "carve"
+	// EntityResponse for events about response actions, by ID
+	EntityResponse string = "response"
+	// EntityEndpoint for test events, by endpoint name
+	EntityEndpoint string = "endpoint"
+)
+
+const (
+	// StatePending for deliveries waiting for their next attempt
+	StatePending string = "pending"
+	// StateDelivered for deliveries accepted by the endpoint
+	StateDelivered string = "delivered"
+	// StateFailed for deliveries that ran out of attempts, kept as dead letters until redelivered
+	StateFailed string = "failed"
+)
+
+const (
+	// Prefix of the generated secrets of endpoints
+	secretPrefix = "whsec_"
+	// How long the enabled endpoints are cached before reading them again from the DB
+	endpointsCacheTTL = 60 * time.Second
+)
+
+// Valid names of endpoints
+var endpointName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// WebhookEndpoint to deliver the events of one environment, signed with its secret.
+// Events is a comma separated list of the subscribed events, all events if empty.
+type WebhookEndpoint struct {
+	gorm.Model
+	EnvironmentID uint `gorm:"index"`
+	Name          string
+	URL           string
+	Secret        string `json:"-"`
+	Events        string
+	Enabled       bool
+	Creator       string
+	RotatedAt     time.Time
+}
+
+// WebhookDelivery to keep track of one event sent to one endpoint, until it is delivered or it runs out of attempts
+type WebhookDelivery struct {
+	gorm.Model
+	EndpointID    uint `gorm:"index"`
+	Endpoint      string
+	EnvironmentID uint `gorm:"index"`
+	EventID       string
+	EventType     string
+	EntityType    string
+	EntityID      string `gorm:"index"`
+	Payload       string `json:"-"`
+	State         string `gorm:"index"`
+	Attempts      int
+	NextAttempt   time.Time
+	StatusCode    int
+	Error         string
+	DeliveredAt   time.Time
+}
+
+// WebhookAttempt to record every attempt of a delivery, with the response of the endpoint
+type WebhookAttempt struct {
+	gorm.Model
+	DeliveryID    uint `gorm:"index"`
+	EndpointID    uint
+	Endpoint      string
+	EnvironmentID uint
+	EventID       string
+	EventType     string
+	EntityType    string
+	EntityID      string `gorm:"index"`
+	Attempt       int
+	StatusCode    int
+	Error         string
+	Duration      int64
+}
+
+// Entity to identify what an event is about
+type Entity struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// Event to be serialized as the body of deliveries
+type Event struct {
+	ID          string      `json:"id"`
+	Type        string      `json:"type"`
+	Timestamp   time.Time   `json:"timestamp"`
+	Environment string      `json:"environment"`
+	Entity      Entity      `json:"entity"`
+	Data        interface{} `json:"data"`
+}
+
+// endpointsCache to keep the enabled endpoints of environments in memory between DB reads
+type endpointsCache struct {
+	mux       sync.Mutex
+	endpoints map[uint][]WebhookEndpoint
+	expires   time.Time
+}
+
+// WebhookManager to handle webhook endpoints and their deliveries
+type WebhookManager struct {
+	DB          *gorm.DB
+	Client      HTTPClient
+	MaxAttempts int
+	cache       endpointsCache
+}
+
+// CreateWebhooks to initialize the webhooks struct and tables
+func CreateWebhooks(backend *gorm.DB) *WebhookManager {
+	var m *WebhookManager = &WebhookManager{DB: backend, Client: defaultClient(), MaxAttempts: DefaultMaxAttempts}
+	// table webhook_endpoints
+	if err := backend.AutoMigrate(&WebhookEndpoint{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (webhook_endpoints): %v", err)
+	}
+	// table webhook_deliveries
+	if err := backend.AutoMigrate(&WebhookDelivery{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (webhook_deliveries): %v", err)
+	}
+	// table webhook_attempts
+	if err := backend.AutoMigrate(&WebhookAttempt{}); err != nil {
+		log.Fatalf("Failed to AutoMigrate table (webhook_attempts): %v", err)
+	}
+	return m
+}
+
+// GenSecret to generate a new secret for an endpoint
+func GenSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return secretPrefix + hex.EncodeToString(b), nil
+}
+
+// Helper to generate the ID of an event
+func genEventID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("evt_%d", time.Now().UnixNano())
+	}
+	return "evt_" + hex.EncodeToString(b)
+}
+
+// EndpointEvents to get the events an endpoint is subscribed to
+func EndpointEvents(e WebhookEndpoint) []string {
+	var events []string
+	for _, ev := range strings.Split(e.Events, ",") {
+		ev = strings.TrimSpace(ev)
+		if ev != "" {
+			events = append(events, ev)
+		}
+	}
+	return events
+}
+
+// Subscribed to check if an endpoint gets an event, test events go to every endpoint
+func Subscribed(e WebhookEndpoint, event string) bool {
+	events := EndpointEvents(e)
+	if len(events) == 0 || event == EventTest {
+		return true
+	}
+	for _, ev := range events {
+		if ev == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper to check if an event is valid
+func validEvent(event string) bool {
+	for _, ev := range Events {
+		if ev == event {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateEndpoint to check an endpoint before saving it
+func ValidateEndpoint(e WebhookEndpoint) error {
+	if !endpointName.MatchString(e.Name) {
+		return fmt.Errorf("invalid endpoint name %q", e.Name)
+	}
+	u, err := url.Parse(e.URL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("invalid endpoint URL %q", e.URL)
+	}
+	for _, ev := range EndpointEvents(e) {
+		if !validEvent(ev) {
+			return fmt.Errorf("invalid event %s", ev)
+		}
+	}
+	return nil
+}
+
+// CreateEndpoint to add an enabled endpoint to an environment, with a new secret
+func (m *WebhookManager) CreateEndpoint(envid uint, e WebhookEndpoint) (WebhookEndpoint, error) {
+	e.EnvironmentID = envid
+	if err := ValidateEndpoint(e); err != nil {
+		return e, err
+	}
+	if _, err := m.GetEndpoint(envid, e.Name); err == nil {
+		return e, fmt.Errorf("endpoint %s already exists", e.Name)
+	}
+	secret, err := GenSecret()
+	if err != nil {
+		return e, fmt.Errorf("GenSecret %v", err)
+	}
+	e.Secret = secret
+	e.Enabled = true
+	e.RotatedAt = time.Now()
+	if err := m.DB.Create(&e).Error; err != nil {
+		return e, fmt.Errorf("Create %v", err)
+	}
+	m.invalidate()
+	return e, nil
+}
+
+// GetEndpoint to get an endpoint of an environment by name
+func (m *WebhookManager) GetEndpoint(envid uint, name string) (WebhookEndpoint, error) {
+	var e WebhookEndpoint
+	if err := m.DB.Where("environment_id = ? AND name = ?", envid, name).First(&e).Error; err != nil {
+		return e, err
+	}
+	return e, nil
+}
+
+// Endpoints to get all the endpoints of an environment
+func (m *WebhookManager) Endpoints(envid uint) ([]WebhookEndpoint, error) {
+	var endpoints []WebhookEndpoint
+	if err := m.DB.Where("environment_id = ?", envid).Order("name").Find(&endpoints).Error; err != nil {
+		return endpoints, err
+	}
+	return endpoints, nil
+}
+
+// RotateSecret to replace the secret of an endpoint, pending deliveries are signed with the new secret
+func (m *WebhookManager) RotateSecret(envid uint, name string) (WebhookEndpoint, error) {
+	e, err := m.GetEndpoint(envid, name)
+	if err != nil {
+		return e, err
+	}
+	secret, err := GenSecret()
+	if err != nil {
+		return e, fmt.Errorf("GenSecret %v", err)
+	}
+	now := time.Now()
+	if err := m.DB.Model(&e).Updates(map[string]interface{}{"secret": secret, "rotated_at": now}).Error; err != nil {
+		return e, fmt.Errorf("Updates %v", err)
+	}
+	e.Secret, e.RotatedAt = secret, now
+	m.invalidate()
+	return e, nil
+}
+
+// SetEnabled to enable or disable an endpoint, disabled endpoints get no new deliveries
+func (m *WebhookManager) SetEnabled(envid uint, name string, enabled bool) (WebhookEndpoint, error) {
+	e, err := m.GetEndpoint(envid, name)
+	if err != nil {
+		return e, err
+	}
+	if err := m.DB.Model(&e).Update("enabled", enabled).Error; err != nil {
+		return e, fmt.Errorf("Update %v", err)
+	}
+	e.Enabled = enabled
+	m.invalidate()
+	return e, nil
+}
+
+// DeleteEndpoint to remove an endpoint of an environment, with its pending deliveries
+func (m *WebhookManager) DeleteEndpoint(envid uint, name string) error {
+	e, err := m.GetEndpoint(envid, name)
+	if err != nil {
+		return err
+	}
+	if err := m.DB.Where("endpoint_id = ? AND state = ?", e.ID, StatePending).Delete(&WebhookDelivery{}).Error; err != nil {
+		return fmt.Errorf("Delete %v", err)
+	}
+	if err := m.DB.Delete(&e).Error; err != nil {
+		return fmt.Errorf("Delete %v", err)
+	}
+	m.invalidate()
+	return nil
+}
+
+// Helper to force the next read of endpoints from the DB
+func (m *WebhookManager) invalidate() {
+	m.cache.mux.Lock()
+	m.cache.expires = time.Time{}
+	m.cache.mux.Unlock()
+}
+
+// EnabledEndpoints to get the enabled endpoints of an environment, cached so producers do not read the DB every time
+func (m *WebhookManager) EnabledEndpoints(envid uint) ([]WebhookEndpoint, error) {
+	m.cache.mux.Lock()
+	defer m.cache.mux.Unlock()
+	if time.Now().After(m.cache.expires) {
+		var endpoints []WebhookEndpoint
+		if err := m.DB.Where("enabled = ?", true).Find(&endpoints).Error; err != nil {
+			return nil, err
+		}
+		m.cache.endpoints = make(map[uint][]WebhookEndpoint)
+		for _, e := range endpoints {
+			m.cache.endpoints[e.EnvironmentID] = append(m.cache.endpoints[e.EnvironmentID], e)
+		}
+		m.cache.expires = time.Now().Add(endpointsCacheTTL)
+	}
+	return m.cache.endpoints[envid], nil
+}
+
+// Helper to get the name of an environment for the body of events
+func (m *WebhookManager) environmentName(envid uint) string {
+	var names []string
+	if err := m.DB.Model(&environments.TLSEnvironment{}).Where("id = ?", envid).Pluck("name", &names).Error; err != nil || len(names) == 0 {
+		return ""
+	}
+	return names[0]
+}
+
+// NewEvent to prepare an event about an entity of an environment
+func NewEvent(eventType, environment string, entity Entity, data interface{}, now time.Time) Event {
+	return Event{
+		ID:          genEventID(),
+		Type:        eventType,
+		Timestamp:   now.UTC(),
+		Environment: environment,
+		Entity:      entity,
+		Data:        data,
+	}
+}
+
+// Helper to queue one event for one endpoint
+func (m *WebhookManager) queue(e WebhookEndpoint, event Event, payload []byte) (WebhookDelivery, error) {
+	d := WebhookDelivery{
+		EndpointID:    e.ID,
+		Endpoint:      e.Name,
+		EnvironmentID: e.EnvironmentID,
+		EventID:       event.ID,
+		EventType:     event.Type,
+		EntityType:    event.Entity.Type,
+		EntityID:      event.Entity.ID,
+		Payload:       string(payload),
+		State:         StatePending,
+		NextAttempt:   event.Timestamp,
+	}
+	if err := m.DB.Create(&d).Error; err != nil {
+		return d, fmt.Errorf("Create %v", err)
+	}
+	return d, nil
+}
+
+// Publish to queue an event for the enabled endpoints of an environment subscribed to it. Events are only
+// stored here, so producers do not wait for endpoints, the delivery worker sends them.
+func (m *WebhookManager) Publish(envid uint, eventType string, entity Entity, data interface{}) (int, error) {
+	endpoints, err := m.EnabledEndpoints(envid)
+	if err != nil {
+		return 0, fmt.Errorf("EnabledEndpoints %v", err)
+	}
+	var subscribed []WebhookEndpoint
+	for _, e := range endpoints {
+		if Subscribed(e, eventType) {
+			subscribed = append(subscribed, e)
+		}
+	}
+	if len(subscribed) == 0 {
+		return 0, nil
+	}
+	event := NewEvent(eventType, m.environmentName(envid), entity, data, time.Now())
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return 0, fmt.Errorf("Marshal %v", err)
+	}
+	queued := 0
+	for _, e := range subscribed {
+		if _, err := m.queue(e, event, payload); err != nil {
+			return queued, err
+		}
+		queued++
+	}
+	return queued, nil
+}
+
+// GetDelivery to get a delivery of an endpoint by ID
+func (m *WebhookManager) GetDelivery(endpointid, id uint) (WebhookDelivery, error) {
+	var d WebhookDelivery
+	if err := m.DB.Where("endpoint_id = ? AND id = ?", endpointid, id).First(&d).Error; err != nil {
+		return d, err
+	}
+	return d, nil
+}
+
+// Deliveries to get the latest deliveries of an endpoint, with one state or all if empty
+func (m *WebhookManager) Deliveries(endpointid uint, state string, limit int) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	query := m.DB.Where("endpoint_id = ?", endpointid)
+	if state != "" {
+		query = query.Where("state = ?", state)
+	}
+	if err := query.Order("created_at desc").Limit(limit).Find(&deliveries).Error; err != nil {
+		return deliveries, err
+	}
+	return deliveries, nil
+}
+
+// DeadLetters to get the number of failed deliveries of each endpoint of an environment
+func (m *WebhookManager) DeadLetters(envid uint) (map[uint]int64, error) {
+	var rows []struct {
+		EndpointID uint
+		Total      int64
+	}
+	if err := m.DB.Model(&WebhookDelivery{}).Select("endpoint_id, count(*) as total").Where(
+		"environment_id = ? AND state = ?", envid, StateFailed,
+	).Group("endpoint_id").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	failed := make(map[uint]int64, len(rows))
+	for _, r := range rows {
+		failed[r.EndpointID] = r.Total
+	}
+	return failed, nil
+}
+
+// EntityAttempts to get all the attempts to deliver events about an entity of an environment, to debug integrations
+func (m *WebhookManager) EntityAttempts(envid uint, entityType, entityID string) ([]WebhookAttempt, error) {
+	var attempts []WebhookAttempt
+	if err := m.DB.Where(
+		"environment_id = ? AND entity_type = ? AND entity_id = ?", envid, entityType, entityID,
+	).Order("created_at").Find(&attempts).Error; err != nil {
+		return attempts, err
+	}
+	return attempts, nil
+}
+
+// Redeliver to queue again a delivery of an endpoint, with all its attempts available
+func (m *WebhookManager) Redeliver(endpointid, id uint) (WebhookDelivery, error) {
+	d, err := m.GetDelivery(endpointid, id)
+	if err != nil {
+		return d, err
+	}
+	if d.State == StatePending {
+		return d, fmt.Errorf("delivery %d is already pending", d.ID)
+	}
+	now := time.Now()
+	updates := map[string]interface{}{"state": StatePending, "attempts": 0, "next_attempt": now}
+	if err := m.DB.Model(&d).Updates(updates).Error; err != nil {
+		return d, fmt.Errorf("Updates %v", err)
+	}
+	d.State, d.Attempts, d.NextAttempt = StatePending, 0, now
+	return d, nil
+}
+
+// RedeliverFailed to queue again all the failed deliveries of an endpoint
+func (m *WebhookManager) RedeliverFailed(endpointid uint) (int64, error) {
+	updates := map[string]interface{}{"state": StatePending, "attempts": 0, "next_attempt": time.Now()}
+	res := m.DB.Model(&WebhookDelivery{}).Where("endpoint_id = ? AND state = ?", endpointid, StateFailed).Updates(updates)
+	if res.Error != nil {
+		return 0, fmt.Errorf("Updates %v", res.Error)
+	}
+	return res.RowsAffected, nil
+}
+
+// TestFire to send a test event to an endpoint right away, failed tests are retried like any other delivery
+func (m *WebhookManager) TestFire(envid uint, name, user string) (WebhookDelivery, error) {
+	e, err := m.GetEndpoint(envid, name)
+	if err != nil {
+		return WebhookDelivery{}, err
+	}
+	event := NewEvent(EventTest, m.environmentName(envid), Entity{Type: EntityEndpoint, ID: e.Name}, map[string]string{"user": user}, time.Now())
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return WebhookDelivery{}, fmt.Errorf("Marshal %v", err)
+	}
+	d, err := m.queue(e, event, payload)
+	if err != nil {
+		return d, err
+	}
+	return m.attempt(e, d, time.Now())
+}
+
+// SerializeEndpoints to record the endpoints of an environment in its activity, without secrets
+func SerializeEndpoints(endpoints []WebhookEndpoint) string {
+	type serialized struct {
+		Name    string `json:"name"`
+		URL     string `json:"url"`
+		Events  string `json:"events"`
+		Enabled bool   `json:"enabled"`
+	}
+	out := make([]serialized, 0, len(endpoints))
+	for _, e := range endpoints {
+		out = append(out, serialized{Name: e.Name, URL: e.URL, Events: e.Events, Enabled: e.Enabled})
+	}
+	b, err := json.Marshal(out)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
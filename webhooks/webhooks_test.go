@@ -0,0 +1,70 @@
+package webhooks
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateEndpoint(t *testing.T) {
+	e := WebhookEndpoint{Name: "soar", URL: "https://soar.example.com/hooks/osctrl"}
+	assert.NoError(t, ValidateEndpoint(e))
+	e.Events = EventQueryCompleted + ", " + EventCarveCompleted
+	assert.NoError(t, ValidateEndpoint(e))
+	e.Events = "query.started"
+	assert.Error(t, ValidateEndpoint(e))
+	e.Events = ""
+	e.URL = "ftp://soar.example.com"
+	assert.Error(t, ValidateEndpoint(e))
+	e.URL = "https://"
+	assert.Error(t, ValidateEndpoint(e))
+	e.URL = "https://soar.example.com"
+	e.Name = "soar hooks"
+	assert.Error(t, ValidateEndpoint(e))
+}
+
+func TestSubscribed(t *testing.T) {
+	e := WebhookEndpoint{}
+	assert.True(t, Subscribed(e, EventResponseFired))
+	e.Events = EventQueryCompleted + "," + EventCarveCompleted
+	assert.True(t, Subscribed(e, EventCarveCompleted))
+	assert.False(t, Subscribed(e, EventResponseFired))
+	assert.True(t, Subscribed(e, EventTest))
+}
+
+func TestBackoff(t *testing.T) {
+	assert.Equal(t, 30*time.Second, Backoff(1))
+	assert.Equal(t, time.Minute, Backoff(2))
+	assert.Equal(t, 4*time.Minute, Backoff(4))
+	assert.Equal(t, 2*time.Hour, Backoff(20))
+}
+
+func TestPost(t *testing.T) {
+	now := time.Now()
+	body := []byte(`{"id":"evt_1","type":"webhook.test"}`)
+	var received http.Header
+	status := http.StatusAccepted
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header
+		b, _ := io.ReadAll(r.Body)
+		assert.NoError(t, Verify("secret", r.Header.Get(HeaderTimestamp), r.Header.Get(HeaderSignature), b, now, DefaultTolerance))
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte("nope"))
+	}))
+	defer ts.Close()
+	e := WebhookEndpoint{Name: "soar", URL: ts.URL, Secret: "secret"}
+	code, err := Post(ts.Client(), e, "evt_1", EventTest, body, now)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusAccepted, code)
+	assert.Equal(t, EventTest, received.Get(HeaderEvent))
+	assert.Equal(t, "evt_1", received.Get(HeaderDelivery))
+	status = http.StatusInternalServerError
+	code, err = Post(ts.Client(), e, "evt_1", EventTest, body, now)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusInternalServerError, code)
+	assert.Contains(t, err.Error(), "nope")
+}